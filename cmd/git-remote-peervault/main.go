@@ -0,0 +1,83 @@
+// git-remote-peervault lets git push to and fetch from repositories kept
+// in PeerVault. With it on the PATH, git runs it for remotes such as
+//
+//	git remote add origin peervault://localhost:8080/repos/app
+//	git clone peervault::https://vault.example.com/repos/app
+//
+// peervault:///repos/app connects to the server of the current CLI
+// profile, or of PEERVAULT_PROFILE. The token of the profile is used
+// unless PEERVAULT_TOKEN is set.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/config"
+	"github.com/Skpow1234/Peervault/internal/cli/profiles"
+	"github.com/Skpow1234/Peervault/internal/cli/secrets"
+	"github.com/Skpow1234/Peervault/internal/gitremote"
+)
+
+func main() {
+	if len(os.Args) < 2 || len(os.Args) > 3 {
+		fmt.Fprintln(os.Stderr, "Usage: git-remote-peervault <remote> [<url>]")
+		fmt.Fprintln(os.Stderr, "It is run by git for peervault:// remotes.")
+		os.Exit(2)
+	}
+	// Without a URL, the remote was given to git as a URL
+	remoteURL := os.Args[len(os.Args)-1]
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, remoteURL); err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: peervault: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, remoteURL string) error {
+	server, repo, err := gitremote.ParseURL(remoteURL)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		cfg = config.Default()
+	}
+	conn, err := profiles.New(cfg, secrets.Open(config.GetConfigDir())).Resolve(os.Getenv("PEERVAULT_PROFILE"))
+	if err != nil {
+		return err
+	}
+	runCfg := *cfg
+	if conn != nil {
+		runCfg.ServerURL = conn.ServerURL
+		runCfg.AuthToken = conn.Token
+	}
+	if server != "" {
+		runCfg.ServerURL = server
+	}
+	if token := os.Getenv("PEERVAULT_TOKEN"); token != "" {
+		runCfg.AuthToken = token
+	}
+
+	c := client.New(&runCfg)
+	if conn != nil {
+		if err := c.SetTLS(conn.TLS); err != nil {
+			return fmt.Errorf("profile %s: %w", conn.Profile, err)
+		}
+	}
+	// Packs take as long as they take; interrupting git cancels ctx
+	c.SetTimeout(0)
+
+	remote, err := gitremote.NewRemote(c, repo)
+	if err != nil {
+		return err
+	}
+	return gitremote.NewHelper(remote, &gitremote.Git{}, remoteURL).Run(ctx, os.Stdin, os.Stdout)
+}
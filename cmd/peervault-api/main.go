@@ -7,24 +7,249 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/Skpow1234/Peervault/internal/api/rest"
+	"github.com/Skpow1234/Peervault/internal/audit"
+	"github.com/Skpow1234/Peervault/internal/autotls"
+	"github.com/Skpow1234/Peervault/internal/backup"
+	"github.com/Skpow1234/Peervault/internal/consensus"
+	"github.com/Skpow1234/Peervault/internal/diagnostics"
+	"github.com/Skpow1234/Peervault/internal/georeplication"
+	"github.com/Skpow1234/Peervault/internal/jsondoc"
+	"github.com/Skpow1234/Peervault/internal/media"
+	"github.com/Skpow1234/Peervault/internal/plugins"
+	"github.com/Skpow1234/Peervault/internal/retention"
+	"github.com/Skpow1234/Peervault/internal/snapshot"
+	"github.com/Skpow1234/Peervault/internal/streamlog"
 )
 
 func main() {
 	// Parse command line flags
 	port := flag.Int("port", 8081, "Port to listen on")
+	searchEnabled := flag.Bool("search", false, "Enable full-text search over uploaded documents")
+	searchIndex := flag.String("search-index", "", "Path to persist the search index (in memory if empty)")
+	shareLinks := flag.String("share-links", "", "Path to persist share links (in memory if empty)")
+	publicURL := flag.String("public-url", "", "Public base URL used in share links")
+	auditLog := flag.String("audit-log", "", "Path of the audit log recording share link access")
+	gatewayEnabled := flag.Bool("gateway", false, "Serve whitelisted files anonymously under /public/")
+	gatewayKeys := flag.String("gateway-keys", "", "Comma-separated file keys the gateway serves")
+	gatewayPrefixes := flag.String("gateway-prefixes", "", "Comma-separated key prefixes the gateway serves")
+	gatewayRPM := flag.Int("gateway-rpm", 60, "Gateway requests per minute per client IP (0 disables)")
+	gatewayBandwidth := flag.Int64("gateway-bandwidth", 1<<20, "Gateway download rate per client IP in bytes per second (0 disables)")
+	gatewayQuota := flag.Int64("gateway-quota", 1<<30, "Gateway bytes per client IP per day (0 disables)")
+	gatewayCacheSize := flag.Int64("gateway-cache-size", 64<<20, "Bytes of public files the gateway caches in memory (0 disables)")
+	gatewayCachePolicy := flag.String("gateway-cache-policy", "lru", "Eviction policy of the gateway cache: lru or arc")
+	lifecyclePolicy := flag.String("lifecycle-policy", "", "Path to persist lifecycle rules (in memory if empty)")
+	lifecycleInterval := flag.Duration("lifecycle-interval", time.Hour, "How often lifecycle rules are evaluated")
+	lifecycleEnforce := flag.Bool("lifecycle-enforce", false, "Apply lifecycle actions on scheduled runs instead of only reporting them")
+	uploadDir := flag.String("upload-dir", "", "Directory staging resumable uploads (under the system temp dir if empty)")
+	locksPath := flag.String("locks", "", "Path to persist retention locks and legal holds (in memory if empty)")
+	backupConfig := flag.String("backup-config", "", "YAML file listing backup jobs, targets and cron schedules")
+	pluginPaths := flag.String("plugins", "", "Comma-separated name=path plugin executables to launch; their storage serves plugin backup targets and their auth providers check API tokens")
+	jsonSchemas := flag.String("json-schemas", "", "YAML file binding JSON Schemas to the key prefixes of JSON documents (any JSON accepted if empty)")
+	snapshotConfig := flag.String("snapshot-config", "", "YAML file with the snapshot directory and cron schedules (in memory, on demand only if empty)")
+	clusterID := flag.String("cluster-id", "", "Name of this cluster; enables geo-replication (secret from PEERVAULT_REPLICATION_SECRET)")
+	replicateTo := flag.String("replicate-to", "", "Comma-separated name=url clusters to replicate changes to")
+	replicationPolicy := flag.String("replication-policy", "last-writer-wins", "Conflict policy for replicated changes: last-writer-wins or source-wins")
+	replicationState := flag.String("replication-state", "", "Directory to persist replication checkpoints (in memory if empty)")
+	thumbnails := flag.Bool("thumbnails", false, "Make thumbnails of stored images and videos and record their dimensions")
+	thumbnailSizes := flag.String("thumbnail-sizes", "64,256,1024", "Comma-separated longest edges in pixels thumbnails are made at")
+	thumbnailsOnIngest := flag.Bool("thumbnails-on-ingest", false, "Render thumbnails when files are stored instead of on first request")
+	ffmpegPath := flag.String("ffmpeg", "", "ffmpeg binary grabbing poster frames of videos and transcoding streams (videos get no thumbnails or streams if empty)")
+	streamProfiles := flag.String("stream-profiles", "", "Comma-separated HLS profiles as name:height:video-bitrate:audio-bitrate (default 360p:360:800k:96k,720p:720:2800k:128k)")
+	segmentSeconds := flag.Int("segment-seconds", media.DefaultSegmentSeconds, "Length in seconds of HLS stream segments")
+	logs := flag.Bool("logs", false, "Serve append-only logs of records under /api/v1/logs")
+	logSegmentBytes := flag.Int64("log-segment-bytes", streamlog.DefaultSegmentBytes, "Size in bytes at which a log segment is sealed")
+	logMaxAge := flag.Duration("log-max-age", 0, "Drop log records older than this (kept forever if 0)")
+	logMaxBytes := flag.Int64("log-max-bytes", 0, "Drop the oldest records of a log larger than this many bytes (unlimited if 0)")
+	dumpOpenAPI := flag.Bool("dump-openapi", false, "Print the OpenAPI document of the REST API and exit")
+	openAPIFormat := flag.String("openapi-format", "yaml", "Format of -dump-openapi: yaml or json")
+	openAPIOut := flag.String("openapi-out", "", "File -dump-openapi writes to (stdout if empty)")
+	raftMembers := flag.String("raft-members", "", "Comma-separated URLs of the Raft group members whose locks and leases to serve")
+	raftToken := flag.String("raft-token", os.Getenv(consensus.TokenEnv), "Token of the Raft group")
+	diag := diagnostics.RegisterFlags(flag.CommandLine)
+	tlsFlags := autotls.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
+	if *dumpOpenAPI {
+		if err := writeOpenAPI(*openAPIFormat, *openAPIOut); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to dump the OpenAPI document: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Create logger
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
 	}))
 
+	// Serve profiles and runtime stats when -diagnostics-addr is set
+	diag.Logger = logger
+	if _, err := diagnostics.Start(*diag); err != nil {
+		logger.Error("Failed to start diagnostics", "error", err)
+		os.Exit(1)
+	}
+
+	// Serve HTTPS when -tls-cert or -acme-domains is set
+	certs, err := tlsFlags.Load()
+	if err != nil {
+		logger.Error("Failed to load TLS certificates", "error", err)
+		os.Exit(1)
+	}
+
 	// Create server configuration
 	restConfig := rest.DefaultConfig()
 	restConfig.Port = ":" + fmt.Sprintf("%d", *port)
+	restConfig.TLSConfig = certs.Config()
+	restConfig.SearchEnabled = *searchEnabled
+	restConfig.SearchIndexPath = *searchIndex
+	restConfig.ShareSecret = os.Getenv("PEERVAULT_SHARE_SECRET")
+	restConfig.ShareLinksPath = *shareLinks
+	restConfig.PublicBaseURL = *publicURL
+	restConfig.UploadDir = *uploadDir
+	if *publicURL == "" {
+		restConfig.PublicBaseURL = fmt.Sprintf("http://localhost:%d", *port)
+	}
+
+	restConfig.GatewayConfig.Enabled = *gatewayEnabled
+	restConfig.GatewayConfig.Keys = splitList(*gatewayKeys)
+	restConfig.GatewayConfig.Prefixes = splitList(*gatewayPrefixes)
+	restConfig.GatewayConfig.RequestsPerMin = *gatewayRPM
+	restConfig.GatewayConfig.BytesPerSecond = *gatewayBandwidth
+	restConfig.GatewayConfig.QuotaBytes = *gatewayQuota
+	restConfig.GatewayConfig.CacheSize = *gatewayCacheSize
+	restConfig.GatewayConfig.CachePolicy = *gatewayCachePolicy
+	if *gatewayEnabled && len(restConfig.GatewayConfig.Keys) == 0 && len(restConfig.GatewayConfig.Prefixes) == 0 {
+		logger.Warn("Gateway enabled without -gateway-keys or -gateway-prefixes; nothing will be public")
+	}
+
+	restConfig.LifecyclePolicyPath = *lifecyclePolicy
+	restConfig.LifecycleInterval = *lifecycleInterval
+	restConfig.LifecycleEnforce = *lifecycleEnforce
+
+	if *auditLog != "" {
+		if err := audit.InitializeAuditLogger(*auditLog); err != nil {
+			logger.Error("Failed to open audit log", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Locks are loaded up front: starting without them would let
+	// protected files be deleted
+	locks, err := retention.NewManager(retention.ManagerOpts{Path: *locksPath})
+	if err != nil {
+		logger.Error("Failed to load retention locks", "error", err)
+		os.Exit(1)
+	}
+	restConfig.Retention = locks
+
+	// Plugins are launched before the backup jobs writing to them load
+	for _, item := range splitList(*pluginPaths) {
+		name, path, ok := strings.Cut(item, "=")
+		if !ok || name == "" || path == "" {
+			logger.Error("Invalid -plugins entry, want name=path", "entry", item)
+			os.Exit(1)
+		}
+		p, err := plugins.LaunchExternal(plugins.ExternalConfig{Name: name, Path: path}, logger)
+		if err != nil {
+			logger.Error("Failed to launch plugin", "plugin", name, "error", err)
+			os.Exit(1)
+		}
+		if auth := p.Auth(); auth != nil {
+			restConfig.AuthProviders = append(restConfig.AuthProviders, auth)
+		}
+	}
+	defer func() { _ = plugins.StopExternal() }()
+
+	if *backupConfig != "" {
+		cfg, err := backup.LoadConfig(*backupConfig)
+		if err != nil {
+			logger.Error("Failed to load backup config", "error", err)
+			os.Exit(1)
+		}
+		restConfig.Backup = cfg
+	}
+
+	if *jsonSchemas != "" {
+		schemas, err := jsondoc.LoadConfig(*jsonSchemas)
+		if err != nil {
+			logger.Error("Failed to load JSON schemas", "error", err)
+			os.Exit(1)
+		}
+		restConfig.JSONSchemas = schemas
+	}
+
+	if *snapshotConfig != "" {
+		cfg, err := snapshot.LoadConfig(*snapshotConfig)
+		if err != nil {
+			logger.Error("Failed to load snapshot config", "error", err)
+			os.Exit(1)
+		}
+		restConfig.Snapshots = cfg
+	}
+
+	if *thumbnails {
+		sizes, err := parseSizes(*thumbnailSizes)
+		if err != nil {
+			logger.Error("Invalid -thumbnail-sizes", "error", err)
+			os.Exit(1)
+		}
+		profiles, err := media.ParseProfiles(*streamProfiles)
+		if err != nil {
+			logger.Error("Invalid -stream-profiles", "error", err)
+			os.Exit(1)
+		}
+		restConfig.Media = &media.Config{
+			Sizes:          sizes,
+			OnIngest:       *thumbnailsOnIngest,
+			FFmpeg:         *ffmpegPath,
+			Profiles:       profiles,
+			SegmentSeconds: *segmentSeconds,
+		}
+	}
+
+	if *logs {
+		restConfig.Logs = &streamlog.Config{SegmentBytes: *logSegmentBytes, MaxAge: *logMaxAge, MaxBytes: *logMaxBytes}
+	}
+
+	if *clusterID != "" {
+		targets, err := georeplication.ParseTargets(*replicateTo)
+		if err != nil {
+			logger.Error("Invalid -replicate-to", "error", err)
+			os.Exit(1)
+		}
+		policy, err := georeplication.ParsePolicy(*replicationPolicy)
+		if err != nil {
+			logger.Error("Invalid -replication-policy", "error", err)
+			os.Exit(1)
+		}
+		secret := os.Getenv("PEERVAULT_REPLICATION_SECRET")
+		if secret == "" {
+			logger.Error("Geo-replication requires PEERVAULT_REPLICATION_SECRET")
+			os.Exit(1)
+		}
+		restConfig.GeoReplication = &georeplication.Config{
+			ClusterID: *clusterID,
+			Secret:    secret,
+			Policy:    policy,
+			StateDir:  *replicationState,
+			Targets:   targets,
+		}
+	}
+
+	if members := splitList(*raftMembers); len(members) > 0 {
+		cluster, err := consensus.NewClient(members, *raftToken, nil)
+		if err != nil {
+			logger.Error("Invalid -raft-members", "error", err)
+			os.Exit(1)
+		}
+		restConfig.Cluster = cluster
+	}
 
 	// Create and start server
 	server := rest.NewServer(restConfig, logger)
@@ -51,6 +276,58 @@ func main() {
 		logger.Error("Error during shutdown", "error", err)
 		os.Exit(1)
 	}
+	if audit.GlobalAuditLogger != nil {
+		_ = audit.GlobalAuditLogger.Close()
+	}
 
 	logger.Info("Server stopped gracefully")
 }
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseSizes parses a comma-separated list of thumbnail sizes
+func parseSizes(value string) ([]int, error) {
+	var sizes []int
+	for _, item := range splitList(value) {
+		size, err := strconv.Atoi(item)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid size %q", item)
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+// writeOpenAPI writes the OpenAPI document of the REST API to path, or to
+// stdout when path is empty
+func writeOpenAPI(format, path string) error {
+	doc, err := rest.OpenAPI()
+	if err != nil {
+		return err
+	}
+	var data []byte
+	switch format {
+	case "yaml":
+		data, err = doc.YAML()
+	case "json":
+		data, err = doc.JSON()
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,124 @@
+// Command peervault-bench runs a benchmark workload against a PeerVault
+// cluster and prints a JSON report of throughput, latency percentiles and
+// error rates. Given the report of an earlier run with -baseline, it exits
+// with status 1 when the cluster got slower, so CI can track regressions.
+//
+//	peervault-bench -server http://localhost:8081 -duration 1m -concurrency 16 \
+//		-read-ratio 0.7 -sizes 4KiB:60,64KiB-1MiB:35,16MiB:5 -out bench.json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Skpow1234/Peervault/internal/bench"
+	cliclient "github.com/Skpow1234/Peervault/internal/cli/client"
+	cliconfig "github.com/Skpow1234/Peervault/internal/cli/config"
+	"github.com/Skpow1234/Peervault/pkg/client"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	workload := bench.DefaultWorkload()
+	var (
+		server    string
+		token     string
+		tlsConfig cliconfig.TLSConfig
+		out       string
+		baseline  string
+		tolerance float64
+		verbose   bool
+	)
+	flags := flag.NewFlagSet("peervault-bench", flag.ContinueOnError)
+	flags.StringVar(&server, "server", "http://localhost:8081", "PeerVault REST API URL")
+	flags.StringVar(&token, "token", os.Getenv("PEERVAULT_TOKEN"), "Authentication token (default $PEERVAULT_TOKEN)")
+	flags.StringVar(&tlsConfig.CACert, "ca-cert", "", "Extra CA certificate to trust")
+	flags.StringVar(&tlsConfig.ClientCert, "client-cert", "", "Client certificate for mutual TLS")
+	flags.StringVar(&tlsConfig.ClientKey, "client-key", "", "Key of the client certificate")
+	flags.BoolVar(&tlsConfig.InsecureSkipVerify, "insecure", false, "Skip verification of the server certificate")
+	workload.RegisterFlags(flags)
+	flags.StringVar(&out, "out", "", "Write the JSON report to this file instead of stdout")
+	flags.StringVar(&baseline, "baseline", "", "Exit with status 1 if the run is worse than this earlier report")
+	flags.Float64Var(&tolerance, "tolerance", 0.1, "Share by which figures may be worse than the baseline")
+	flags.BoolVar(&verbose, "verbose", false, "Log progress to stderr")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if err := workload.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var base *bench.Report
+	if baseline != "" {
+		var err error
+		if base, err = bench.LoadReport(baseline); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load baseline: %v\n", err)
+			return 1
+		}
+	}
+
+	opts := []client.Option{client.WithToken(token), client.WithUserAgent("peervault-bench")}
+	if tlsConfig != (cliconfig.TLSConfig{}) {
+		cfg, err := cliclient.NewTLSConfig(&tlsConfig)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		opts = append(opts, client.WithTLSConfig(cfg))
+	}
+	api, err := client.New(server, opts...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var logger *slog.Logger
+	if verbose {
+		logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report, err := bench.NewRunner(bench.ClientTarget{Client: api}, logger).Run(ctx, workload)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if out != "" {
+		err = report.WriteFile(out)
+	} else {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+		return 1
+	}
+
+	if base == nil {
+		return 0
+	}
+	regressions := bench.Compare(base, report, tolerance)
+	for _, r := range regressions {
+		fmt.Fprintf(os.Stderr, "Regression: %s\n", r)
+	}
+	if len(regressions) > 0 {
+		return 1
+	}
+	return 0
+}
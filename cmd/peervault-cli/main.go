@@ -2,8 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/Skpow1234/Peervault/internal/cli"
 	"github.com/Skpow1234/Peervault/internal/cli/aliases"
@@ -11,6 +16,7 @@ import (
 	"github.com/Skpow1234/Peervault/internal/cli/blockchain"
 	"github.com/Skpow1234/Peervault/internal/cli/client"
 	"github.com/Skpow1234/Peervault/internal/cli/commands"
+	"github.com/Skpow1234/Peervault/internal/cli/completion"
 	"github.com/Skpow1234/Peervault/internal/cli/config"
 	"github.com/Skpow1234/Peervault/internal/cli/edge"
 	"github.com/Skpow1234/Peervault/internal/cli/files"
@@ -19,31 +25,153 @@ import (
 	"github.com/Skpow1234/Peervault/internal/cli/integration"
 	"github.com/Skpow1234/Peervault/internal/cli/iot"
 	"github.com/Skpow1234/Peervault/internal/cli/network"
+	"github.com/Skpow1234/Peervault/internal/cli/profiles"
 	"github.com/Skpow1234/Peervault/internal/cli/prompt"
+	"github.com/Skpow1234/Peervault/internal/cli/secrets"
+	"github.com/Skpow1234/Peervault/internal/cli/terminal"
 )
 
+// options are the global flags given before the command
+type options struct {
+	format  string
+	noColor bool
+	quiet   bool
+	profile string
+	server  string
+	token   string
+}
+
+// newFlagSet defines the global flags, stored in opts
+func newFlagSet(opts *options) *flag.FlagSet {
+	flags := flag.NewFlagSet("peervault-cli", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: peervault-cli [flags] [command [args...]]")
+		fmt.Fprintln(flags.Output(), "Without a command, commands are read from stdin when it is not a terminal, otherwise an interactive shell is started.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&opts.format, "format", "", "Output format: table, json, yaml, csv or go-template=<template>")
+	flags.StringVar(&opts.format, "output", "", "Alias for --format")
+	flags.StringVar(&opts.format, "o", "", "Shorthand for --format")
+	flags.BoolVar(&opts.noColor, "no-color", false, "Disable emoji and color in messages")
+	flags.BoolVar(&opts.quiet, "quiet", false, "Only print results, warnings and errors")
+	flags.BoolVar(&opts.quiet, "q", false, "Shorthand for --quiet")
+	flags.StringVar(&opts.profile, "profile", "", "Connection profile to use instead of the current one (or PEERVAULT_PROFILE)")
+	flags.StringVar(&opts.server, "server", "", "PeerVault server URL, overriding the profile")
+	flags.StringVar(&opts.token, "token", "", "Authentication token, overriding the profile")
+	return flags
+}
+
+// parseFlags parses the global flags; the remaining arguments are the
+// command to run and its arguments
+func parseFlags(args []string) (*options, []string, error) {
+	opts := &options{}
+	flags := newFlagSet(opts)
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if opts.format != "" {
+		if err := formatter.New().SetFormat(opts.format); err != nil {
+			fmt.Fprintln(flags.Output(), err)
+			flags.Usage()
+			return nil, nil, err
+		}
+	}
+	return opts, flags.Args(), nil
+}
+
+// completionFlags lists the global flags for shell completion scripts
+func completionFlags() []completion.Flag {
+	var flags []completion.Flag
+	newFlagSet(&options{}).VisitAll(func(f *flag.Flag) {
+		name := "--" + f.Name
+		if len(f.Name) == 1 {
+			name = "-" + f.Name
+		}
+		boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completion.Flag{
+			Name:        name,
+			Description: f.Usage,
+			TakesValue:  !ok || !boolFlag.IsBoolFlag(),
+		})
+	})
+	return flags
+}
+
 func main() {
+	opts, args, err := parseFlags(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(cli.ExitOK)
+		}
+		os.Exit(cli.ExitUsage)
+	}
+	interactive := len(args) == 0 && terminal.IsTerminalFile(os.Stdin)
+
 	// Initialize CLI
 	cliApp := cli.New()
 
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
-		fmt.Printf("Warning: Could not load config: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Warning: Could not load config: %v\n", err)
 		cfg = config.Default()
 	}
+	profileManager := profiles.New(cfg, secrets.Open(config.GetConfigDir()))
+
+	// Connect with --profile, PEERVAULT_PROFILE or the current profile, in
+	// that order, then the server_url of the config. Flags override all of
+	// them and only apply to this run, so they go into a copy of the config
+	// that is never saved.
+	conn, err := resolveConnection(profileManager, opts.profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if errors.Is(err, profiles.ErrNotFound) {
+			os.Exit(cli.ExitUsage)
+		}
+		os.Exit(cli.ExitFailure)
+	}
+	runCfg := *cfg
+	if conn != nil {
+		runCfg.ServerURL = conn.ServerURL
+		runCfg.AuthToken = conn.Token
+	}
+	if opts.server != "" {
+		runCfg.ServerURL = opts.server
+	}
+	if opts.token != "" {
+		runCfg.AuthToken = opts.token
+	}
+	if opts.format != "" {
+		runCfg.OutputFormat = opts.format
+	}
 
 	// Initialize client
-	client := client.New(cfg)
+	client := client.New(&runCfg)
+	if conn != nil {
+		if err := client.SetTLS(conn.TLS); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: profile %s: %v\n", conn.Profile, err)
+			os.Exit(cli.ExitFailure)
+		}
+	}
 
-	// Initialize formatter
+	// Initialize formatter. Outside the interactive shell, messages go to
+	// stderr so results can be piped, and decoration is dropped when stdout
+	// isn't a terminal.
 	formatter := formatter.New()
+	if runCfg.OutputFormat != "" {
+		if err := formatter.SetFormat(runCfg.OutputFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v; using table output\n", err)
+		}
+	}
+	formatter.SetQuiet(opts.quiet)
+	formatter.SetColor(!opts.noColor && os.Getenv("NO_COLOR") == "" && (interactive || terminal.IsTerminal()))
+	if !interactive {
+		formatter.SetMessageOutput(os.Stderr)
+	}
 
 	// Initialize history
-	hist := history.New(cfg.HistoryFile)
-
-	// Initialize prompt
-	prompt := prompt.New(cfg, hist)
+	hist := history.New(runCfg.HistoryFile)
 
 	// Initialize alias manager
 	aliasManager := aliases.New()
@@ -80,19 +208,71 @@ func main() {
 	integrationManager := integration.NewIntegrationManager(client, configDir)
 
 	// Register commands
-	registerCommands(cliApp, client, formatter, hist, aliasManager, versionManager, shareManager, compressionManager, deduplicationManager, streamingManager, loadBalancer, cacheManager, cdnManager, bandwidthManager, deviceManager, edgeManager, walletManager, contractManager, dashboardManager, visualizationManager, webhookManager, workflowManager, integrationManager)
+	registerCommands(cliApp, client, formatter, hist, aliasManager, versionManager, shareManager, compressionManager, deduplicationManager, streamingManager, loadBalancer, cacheManager, cdnManager, bandwidthManager, deviceManager, edgeManager, walletManager, contractManager, dashboardManager, visualizationManager, webhookManager, workflowManager, integrationManager, profileManager)
+
+	if !interactive {
+		os.Exit(runNonInteractive(cliApp, formatter, aliasManager, args))
+	}
 
 	// Start interactive mode
-	runInteractiveMode(cliApp, client, formatter, prompt, cfg, hist, aliasManager)
+	prompt := prompt.New(&runCfg, hist)
+	runInteractiveMode(cliApp, client, formatter, prompt, &runCfg, hist, aliasManager)
 }
 
-func registerCommands(cliApp *cli.CLI, client *client.Client, formatter *formatter.Formatter, hist *history.History, aliasManager *aliases.Manager, versionManager *files.VersionManager, shareManager *files.ShareManager, compressionManager *files.CompressionManager, deduplicationManager *files.DeduplicationManager, streamingManager *files.StreamingManager, loadBalancer *network.LoadBalancer, cacheManager *network.CacheManager, cdnManager *network.CDNManager, bandwidthManager *network.BandwidthManager, deviceManager *iot.DeviceManager, edgeManager *edge.EdgeManager, walletManager *blockchain.WalletManager, contractManager *blockchain.ContractManager, dashboardManager *analytics.DashboardManager, visualizationManager *analytics.VisualizationManager, webhookManager *integration.WebhookManager, workflowManager *integration.WorkflowManager, integrationManager *integration.IntegrationManager) {
+// resolveConnection returns the connection of the requested profile, or of
+// the current one. A broken current profile only warns, so that profile
+// commands can still fix it; one asked for explicitly is an error.
+func resolveConnection(profileManager *profiles.Manager, name string) (*profiles.Connection, error) {
+	if name == "" {
+		name = os.Getenv("PEERVAULT_PROFILE")
+	}
+	if name != "" {
+		return profileManager.Resolve(name)
+	}
+
+	conn, err := profileManager.Resolve("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v; using server_url from the config\n", err)
+		return nil, nil
+	}
+	return conn, nil
+}
+
+// runNonInteractive runs the command given on the command line, or each line
+// read from stdin, and returns the process exit code
+func runNonInteractive(cliApp *cli.CLI, formatter *formatter.Formatter, aliasManager *aliases.Manager, args []string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	if len(args) > 0 {
+		err = cliApp.Execute(ctx, args[0], args[1:])
+	} else {
+		err = cliApp.RunScript(ctx, os.Stdin, aliasManager.ExpandAliases)
+	}
+	if err != nil {
+		formatter.PrintError(err)
+	}
+	return cli.ExitCode(err)
+}
+
+func registerCommands(cliApp *cli.CLI, client *client.Client, formatter *formatter.Formatter, hist *history.History, aliasManager *aliases.Manager, versionManager *files.VersionManager, shareManager *files.ShareManager, compressionManager *files.CompressionManager, deduplicationManager *files.DeduplicationManager, streamingManager *files.StreamingManager, loadBalancer *network.LoadBalancer, cacheManager *network.CacheManager, cdnManager *network.CDNManager, bandwidthManager *network.BandwidthManager, deviceManager *iot.DeviceManager, edgeManager *edge.EdgeManager, walletManager *blockchain.WalletManager, contractManager *blockchain.ContractManager, dashboardManager *analytics.DashboardManager, visualizationManager *analytics.VisualizationManager, webhookManager *integration.WebhookManager, workflowManager *integration.WorkflowManager, integrationManager *integration.IntegrationManager, profileManager *profiles.Manager) {
 	// File operations
 	cliApp.RegisterCommand("store", commands.NewStoreCommand(client, formatter))
 	cliApp.RegisterCommand("get", commands.NewGetCommand(client, formatter))
 	cliApp.RegisterCommand("list", commands.NewListCommand(client, formatter))
 	cliApp.RegisterCommand("delete", commands.NewDeleteCommand(client, formatter))
 	cliApp.RegisterCommand("ls", commands.NewListCommand(client, formatter)) // Alias
+	cliApp.RegisterCommand("dir", commands.NewDirectoryCommand(client, formatter))
+	cliApp.RegisterCommand("du", commands.NewDuCommand(client, formatter))
+	cliApp.RegisterCommand("fsck", commands.NewFsckCommand(client, formatter))
+	cliApp.RegisterCommand("cp", commands.NewCopyCommand(client, formatter))
+	cliApp.RegisterCommand("mv", commands.NewMoveCommand(client, formatter))
+	cliApp.RegisterCommand("compose", commands.NewComposeCommand(client, formatter))
+	cliApp.RegisterCommand("json", commands.NewJSONCommand(client, formatter))
+	cliApp.RegisterCommand("log", commands.NewLogCommand(client, formatter))
+	cliApp.RegisterCommand("tag", commands.NewTagCommand(client, formatter))
+	cliApp.RegisterCommand("attr", commands.NewAttrCommand(client, formatter))
 
 	// Peer operations
 	cliApp.RegisterCommand("peers", commands.NewPeersCommand(client, formatter))
@@ -127,10 +307,56 @@ func registerCommands(cliApp *cli.CLI, client *client.Client, formatter *formatt
 	cliApp.RegisterCommand("backup", commands.NewBackupCommand(client, formatter))
 	cliApp.RegisterCommand("restore", commands.NewRestoreCommand(client, formatter))
 
+	// Metadata replication
+	cliApp.RegisterCommand("metadata", commands.NewMetadataCommand(client, formatter))
+
+	// Raft group holding cluster metadata
+	cliApp.RegisterCommand("cluster", commands.NewClusterCommand(client, formatter))
+
+	// Geo-replication between clusters
+	cliApp.RegisterCommand("replication", commands.NewReplicationCommand(client, formatter))
+
+	// Per-file replica status
+	cliApp.RegisterCommand("replicas", commands.NewReplicasCommand(client, formatter))
+
+	// Taking the node out of the cluster
+	cliApp.RegisterCommand("decommission", commands.NewDecommissionCommand(client, formatter))
+
+	// Bulk migration
+	cliApp.RegisterCommand("import", commands.NewImportCommand(client, formatter))
+	cliApp.RegisterCommand("export", commands.NewExportCommand(client, formatter))
+
+	// Full-text search
+	cliApp.RegisterCommand("search", commands.NewSearchCommand(client, formatter))
+
+	// Retention locks and legal holds
+	cliApp.RegisterCommand("lock", commands.NewLockCommand(client, formatter))
+
+	// Lifecycle rules
+	cliApp.RegisterCommand("lifecycle", commands.NewLifecycleCommand(client, formatter))
+
+	// Periodic jobs of the server
+	cliApp.RegisterCommand("jobs", commands.NewJobsCommand(client, formatter))
+	cliApp.RegisterCommand("queue", commands.NewQueueCommand(client, formatter))
+
+	// Content policy rules
+	cliApp.RegisterCommand("policy", commands.NewPolicyCommand(client, formatter))
+
+	// Lifecycle hooks
+	cliApp.RegisterCommand("hooks", commands.NewHooksCommand(client, formatter))
+
+	// CSV and Parquet exports
+	cliApp.RegisterCommand("exports", commands.NewExportsCommand(client, formatter))
+
+	// SQL queries over metadata
+	cliApp.RegisterCommand("query", commands.NewQueryCommand(client, formatter))
+
+	// Namespace snapshots
+	cliApp.RegisterCommand("snapshot", commands.NewSnapshotCommand(client, formatter))
+
 	// Configuration
 	cliApp.RegisterCommand("config", commands.NewConfigCommand(client, formatter))
 	cliApp.RegisterCommand("set", commands.NewSetCommand(client, formatter))
-	cliApp.RegisterCommand("get", commands.NewGetConfigCommand(client, formatter))
 
 	// Real-time commands
 	cliApp.RegisterCommand("realtime", commands.NewRealtimeCommand(client, formatter))
@@ -139,11 +365,13 @@ func registerCommands(cliApp *cli.CLI, client *client.Client, formatter *formatt
 	cliApp.RegisterCommand("protocol", commands.NewProtocolCommand(client, formatter))
 	cliApp.RegisterCommand("batch", commands.NewBatchCommand(client, formatter))
 	cliApp.RegisterCommand("monitor", commands.NewMonitorCommand(client, formatter))
+	cliApp.RegisterCommand("bench", commands.NewBenchCommand(client, formatter))
+	cliApp.RegisterCommand("debug", commands.NewDebugCommand(client, formatter))
 
 	// Quick Wins commands
 	cliApp.RegisterCommand("alias", commands.NewAliasCommand(client, formatter))
 	cliApp.RegisterCommand("format", commands.NewFormatCommand(client, formatter))
-	cliApp.RegisterCommand("profile", commands.NewProfileCommand(client, formatter))
+	cliApp.RegisterCommand("profile", commands.NewProfileCommand(client, formatter, profileManager))
 	cliApp.RegisterCommand("macro", commands.NewMacroCommand(client, formatter))
 
 	// Security commands
@@ -173,6 +401,7 @@ func registerCommands(cliApp *cli.CLI, client *client.Client, formatter *formatt
 	cliApp.RegisterCommand("quit", commands.NewExitCommand()) // Alias
 	cliApp.RegisterCommand("clear", commands.NewClearCommand())
 	cliApp.RegisterCommand("history", commands.NewHistoryCommand(hist))
+	cliApp.RegisterCommand("completion", commands.NewCompletionCommand(cliApp, "peervault-cli", completionFlags()))
 }
 
 func runInteractiveMode(cliApp *cli.CLI, client *client.Client, formatter *formatter.Formatter, prompt *prompt.Prompt, cfg *config.Config, hist *history.History, aliasManager *aliases.Manager) {
@@ -208,20 +437,11 @@ func runInteractiveMode(cliApp *cli.CLI, client *client.Client, formatter *forma
 		// Add to history
 		prompt.AddToHistory(expandedInput)
 
-		// Parse and execute command
-		parts := strings.Fields(expandedInput)
-		if len(parts) == 0 {
-			continue
-		}
-
-		command := parts[0]
-		args := parts[1:]
-
 		// Show spinner for long operations
 		spinner := formatter.PrintSpinner("Executing command...")
 
-		// Execute command
-		err = cliApp.Execute(ctx, command, args)
+		// Parse and execute command
+		err = cliApp.ExecuteLine(ctx, expandedInput)
 		spinner.Stop()
 
 		if err != nil {
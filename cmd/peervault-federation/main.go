@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/Skpow1234/Peervault/internal/api/graphql/federation"
+	"github.com/Skpow1234/Peervault/internal/diagnostics"
 )
 
 func main() {
@@ -21,6 +22,7 @@ func main() {
 		configFile          = flag.String("config", "", "Configuration file path")
 		verbose             = flag.Bool("verbose", false, "Enable verbose logging")
 	)
+	diag := diagnostics.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
 	// Setup logging
@@ -35,6 +37,13 @@ func main() {
 		Level: logLevel,
 	}))
 
+	// Serve profiles and runtime stats when -diagnostics-addr is set
+	diag.Logger = logger
+	if _, err := diagnostics.Start(*diag); err != nil {
+		logger.Error("Failed to start diagnostics", "error", err)
+		os.Exit(1)
+	}
+
 	// Create federation configuration
 	config := &federation.FederationConfig{
 		GatewayPort:         *port,
@@ -9,8 +9,11 @@ import (
 	"syscall"
 
 	"github.com/Skpow1234/Peervault/internal/api/graphql"
+	"github.com/Skpow1234/Peervault/internal/api/httpguard"
 	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/autotls"
 	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/diagnostics"
 	"github.com/Skpow1234/Peervault/internal/peer"
 	"github.com/Skpow1234/Peervault/internal/storage"
 	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
@@ -24,12 +27,29 @@ func main() {
 		bootstrapNodes   = flag.String("bootstrap", "", "Comma-separated list of bootstrap nodes")
 		enablePlayground = flag.Bool("playground", true, "Enable GraphQL Playground")
 		logLevel         = flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+		origins          = flag.String("origins", "*", "Comma-separated origins allowed to call the API from browsers (* allows any)")
 	)
+	diag := diagnostics.RegisterFlags(flag.CommandLine)
+	tlsFlags := autotls.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
 	// Set up logging
 	logger := setupLogger(*logLevel)
 
+	// Serve profiles and runtime stats when -diagnostics-addr is set
+	diag.Logger = logger
+	if _, err := diagnostics.Start(*diag); err != nil {
+		logger.Error("Failed to start diagnostics", "error", err)
+		os.Exit(1)
+	}
+
+	// Serve HTTPS when -tls-cert or -acme-domains is set
+	certs, err := tlsFlags.Load()
+	if err != nil {
+		logger.Error("Failed to load TLS certificates", "error", err)
+		os.Exit(1)
+	}
+
 	// Initialize key manager
 	keyManager, err := crypto.NewKeyManager()
 	if err != nil {
@@ -63,8 +83,10 @@ func main() {
 		Port:             *port,
 		PlaygroundPath:   "/playground",
 		GraphQLPath:      "/graphql",
-		AllowedOrigins:   []string{"*"},
+		AllowedOrigins:   strings.Split(*origins, ","),
 		EnablePlayground: *enablePlayground,
+		Security:         httpguard.DefaultPolicy(),
+		TLSConfig:        certs.Config(),
 	}
 
 	graphqlServer := graphql.NewServer(server, config)
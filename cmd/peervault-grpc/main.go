@@ -6,15 +6,30 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/Skpow1234/Peervault/internal/api/grpc"
+	"github.com/Skpow1234/Peervault/internal/api/grpc/interceptors"
+	"github.com/Skpow1234/Peervault/internal/autotls"
+	"github.com/Skpow1234/Peervault/internal/consensus"
+	"github.com/Skpow1234/Peervault/internal/diagnostics"
 )
 
 func main() {
 	// Parse command line flags
 	port := flag.String("port", "8082", "gRPC server port")
 	authToken := flag.String("auth-token", "demo-token", "Authentication token")
+	enableReflection := flag.Bool("reflection", true, "Serve gRPC server reflection")
+	enableChannelz := flag.Bool("channelz", false, "Serve gRPC channelz")
+	requireAuth := flag.Bool("require-auth", false, "Require the auth token or a JWT on calls other than health checks")
+	jwtSecret := flag.String("jwt-secret", os.Getenv("PEERVAULT_GRPC_JWT_SECRET"), "Secret signing HS256 JWTs; empty accepts the auth token only")
+	rateLimit := flag.Int("rate-limit", 0, "Requests per second per client, 0 disables rate limiting")
+	rateLimitBurst := flag.Int("rate-limit-burst", 0, "Requests a client may make in a burst above the rate limit")
+	raftMembers := flag.String("raft-members", "", "Comma-separated URLs of the Raft group members whose locks and leases to serve")
+	raftToken := flag.String("raft-token", os.Getenv(consensus.TokenEnv), "Token of the Raft group")
+	diag := diagnostics.RegisterFlags(flag.CommandLine)
+	tlsFlags := autotls.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
 	// Setup logger
@@ -22,10 +37,44 @@ func main() {
 		Level: slog.LevelInfo,
 	}))
 
+	// Serve profiles and runtime stats when -diagnostics-addr is set
+	diag.Logger = logger
+	if _, err := diagnostics.Start(*diag); err != nil {
+		logger.Error("Failed to start diagnostics", "error", err)
+		os.Exit(1)
+	}
+
+	// Serve HTTPS when -tls-cert or -acme-domains is set
+	certs, err := tlsFlags.Load()
+	if err != nil {
+		logger.Error("Failed to load TLS certificates", "error", err)
+		os.Exit(1)
+	}
+
 	// Create server configuration
 	config := grpc.DefaultConfig()
 	config.Port = ":" + *port
 	config.AuthToken = *authToken
+	config.EnableReflection = *enableReflection
+	config.EnableChannelz = *enableChannelz
+	config.TLSConfig = certs.Config()
+	config.Interceptors = interceptors.DefaultChainConfig()
+	if *requireAuth {
+		config.Interceptors.Auth = interceptors.DefaultAuthConfig()
+		config.Interceptors.Auth.SecretKey = *jwtSecret
+		config.Interceptors.Auth.Tokens = map[string]string{*authToken: "token"}
+	}
+	if *rateLimit > 0 {
+		config.Interceptors.RateLimit = &interceptors.RateLimitConfig{RequestsPerSecond: *rateLimit, Burst: *rateLimitBurst}
+	}
+	if *raftMembers != "" {
+		cluster, err := consensus.NewClient(strings.Split(*raftMembers, ","), *raftToken, nil)
+		if err != nil {
+			logger.Error("Invalid -raft-members", "error", err)
+			os.Exit(1)
+		}
+		config.Cluster = cluster
+	}
 
 	// Create and start server
 	server := grpc.NewServer(config, logger)
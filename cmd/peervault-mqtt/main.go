@@ -14,6 +14,7 @@ import (
 	"github.com/Skpow1234/Peervault/internal/api/mqtt"
 	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
 	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/diagnostics"
 	"github.com/Skpow1234/Peervault/internal/peer"
 	"github.com/Skpow1234/Peervault/internal/storage"
 	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
@@ -29,6 +30,7 @@ func main() {
 		wsPort     = flag.Int("ws-port", 8085, "MQTT over WebSocket port")
 		enableWS   = flag.Bool("enable-ws", true, "Enable MQTT over WebSocket")
 	)
+	diag := diagnostics.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
 	// Setup logging
@@ -41,6 +43,13 @@ func main() {
 		Level: logLevel,
 	}))
 
+	// Serve profiles and runtime stats when -diagnostics-addr is set
+	diag.Logger = logger
+	if _, err := diagnostics.Start(*diag); err != nil {
+		logger.Error("Failed to start diagnostics", "error", err)
+		os.Exit(1)
+	}
+
 	// Create file server instance (simplified for MQTT API)
 	fileServer := createFileServer(*listenAddr, logger)
 
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/logging"
+)
+
+// fsckUnrepaired is the exit code of fsck when problems are left
+const fsckUnrepaired = 2
+
+// fsckCommand checks the store of a stopped node and prints the findings
+// as JSON, exiting with fsckUnrepaired when any is left unrepaired. It
+// takes the node's own flags, so it opens the same store:
+//
+//	peervault-node fsck [-repair] -listen :3000 -versions versions.json -bootstrap node1:3000
+//
+// With -repair, orphaned data is collected and broken objects are
+// quarantined under the store's .quarantine directory; the node joins its
+// bootstrap peers to refetch the files they held. Copies are only known,
+// and refetched, through the version index of -versions, and only decrypt
+// with the cluster key the node runs with.
+func fsckCommand(args []string) (int, error) {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	opts := nodeFlags(fs)
+	repair := fs.Bool("repair", false, "Collect orphaned data, quarantine broken objects and refetch them from peers")
+	peerTimeout := fs.Duration("peer-timeout", 30*time.Second, "How long to wait for bootstrap peers when repairing")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: peervault-node fsck [-repair] [node flags]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 0, err
+	}
+	if *opts.workDir != "" {
+		if err := os.Chdir(*opts.workDir); err != nil {
+			return 0, fmt.Errorf("failed to change to working directory: %w", err)
+		}
+	}
+	// Standard output carries the report
+	logging.ConfigureLoggerOutput(*opts.logLevel, os.Stderr)
+
+	bootstrapList := splitAddrs(*opts.bootstrapNodes)
+	server, err := makeServer(*opts.listenAddr, *opts.storagePrefix, *opts.storageHash, *opts.versionsPath, nil, bootstrapList...)
+	if err != nil {
+		return 0, err
+	}
+	ctx := context.Background()
+	if *repair {
+		if err := server.Start(); err != nil {
+			return 0, fmt.Errorf("failed to start server: %w", err)
+		}
+		defer server.Stop()
+		waitForPeers(server, len(bootstrapList), *peerTimeout)
+	}
+
+	report, err := server.Fsck(ctx, *repair)
+	if err != nil {
+		return 0, err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return 0, err
+	}
+	if report.Unrepaired > 0 {
+		return fsckUnrepaired, nil
+	}
+	return 0, nil
+}
+
+// waitForPeers waits until n peers are connected, or the timeout passes
+func waitForPeers(server *fs.Server, n int, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for len(server.Peers()) < n {
+		if time.Now().After(deadline) {
+			slog.Warn("not every bootstrap peer connected", "connected", len(server.Peers()), "bootstrap", n)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
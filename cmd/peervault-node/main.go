@@ -1,80 +1,391 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/consensus"
 	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/diagnostics"
 	"github.com/Skpow1234/Peervault/internal/logging"
+	"github.com/Skpow1234/Peervault/internal/metadata"
 	"github.com/Skpow1234/Peervault/internal/peer"
+	"github.com/Skpow1234/Peervault/internal/service"
 	"github.com/Skpow1234/Peervault/internal/storage"
 	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
 )
 
+// serviceName is the default name the node is installed under
+const serviceName = "peervault-node"
+
+// nodeOptions are the flags of a running node
+type nodeOptions struct {
+	listenAddr     *string
+	bootstrapNodes *string
+	logLevel       *string
+	logFile        *string
+	workDir        *string
+	storagePrefix  *string
+	storageHash    *string
+	versionsPath   *string
+	metadataAddr   *string
+	metadataRole   *string
+	metadataFrom   *string
+	metadataFence  *string
+	metadataToken  *string
+	storageAdmin   *string
+	storageToken   *string
+	migrateStorage *bool
+	raftAddr       *string
+	raftID         *string
+	raftPeers      *string
+	raftDir        *string
+	raftToken      *string
+	diagnostics    *diagnostics.Options
+}
+
+func nodeFlags(fs *flag.FlagSet) *nodeOptions {
+	return &nodeOptions{
+		listenAddr:     fs.String("listen", ":3000", "Listen address for this node"),
+		bootstrapNodes: fs.String("bootstrap", "", "Comma-separated list of bootstrap node addresses"),
+		logLevel:       fs.String("log-level", "info", "Log level (debug, info, warn, error)"),
+		logFile:        fs.String("log-file", "", "Append logs to this file instead of standard output"),
+		workDir:        fs.String("workdir", "", "Change to this directory before opening storage"),
+		storagePrefix:  fs.String("storage-prefix", "peervault", "Prefix for storage directory"),
+		storageHash:    fs.String("storage-hash", "sha1", "Hash naming object paths and chunks (sha1, sha256, blake3)"),
+		versionsPath:   fs.String("versions", "", "Path to persist file version vectors, the index fsck checks the store against (in memory if empty)"),
+		metadataAddr:   fs.String("metadata-addr", "", "Listen address for metadata replication and admin endpoints (disabled if empty)"),
+		metadataRole:   fs.String("metadata-role", "primary", "Metadata role (primary, standby)"),
+		metadataFrom:   fs.String("metadata-primary", "", "Replication URL of the metadata primary (standby only)"),
+		metadataFence:  fs.String("metadata-fence", "", "Path to the shared fence file used to prevent split-brain (required on a standby)"),
+		metadataToken:  fs.String("metadata-token", os.Getenv(metadata.TokenEnv), "Token the metadata primary, standbys and admin requests authenticate with (default $"+metadata.TokenEnv+")"),
+		storageAdmin:   fs.String("storage-admin-addr", "", "Listen address for storage format migration controls, on loopback unless it names a host (disabled if empty)"),
+		storageToken:   fs.String("storage-admin-token", os.Getenv(storage.MigrationTokenEnv), "Bearer token for storage migration controls (default $"+storage.MigrationTokenEnv+")"),
+		migrateStorage: fs.Bool("migrate-storage", false, "Start (or resume) migrating storage to the chunked format in the background"),
+		raftAddr:       fs.String("raft-addr", "", "Listen address for the Raft group holding cluster metadata (disabled if empty)"),
+		raftID:         fs.String("raft-id", "", "ID of this node in the Raft group"),
+		raftPeers:      fs.String("raft-peers", "", "Comma-separated id=url list of all Raft group members, this node included"),
+		raftDir:        fs.String("raft-dir", "", "Directory persisting the Raft log and snapshots (default <storage-prefix>_raft)"),
+		raftToken:      fs.String("raft-token", os.Getenv(consensus.TokenEnv), "Token the Raft group members authenticate each other with (default $"+consensus.TokenEnv+")"),
+		diagnostics:    diagnostics.RegisterFlags(fs),
+	}
+}
+
 func main() {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "install", "uninstall", "start", "stop", "status":
+			if err := serviceCommand(args[0], args[1:]); err != nil {
+				fmt.Fprintln(os.Stderr, "peervault-node:", err)
+				os.Exit(1)
+			}
+			return
+		case "upgrade":
+			if err := upgradeCommand(args[1:]); err != nil {
+				fmt.Fprintln(os.Stderr, "peervault-node:", err)
+				os.Exit(1)
+			}
+			return
+		case "fsck":
+			code, err := fsckCommand(args[1:])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "peervault-node:", err)
+				os.Exit(1)
+			}
+			os.Exit(code)
+		case "run":
+			args = args[1:]
+		}
+	}
+
 	// Parse command line flags
-	var (
-		listenAddr     = flag.String("listen", ":3000", "Listen address for this node")
-		bootstrapNodes = flag.String("bootstrap", "", "Comma-separated list of bootstrap node addresses")
-		logLevel       = flag.String("log-level", "info", "Log level (debug, info, warn, error)")
-		storagePrefix  = flag.String("storage-prefix", "peervault", "Prefix for storage directory")
-	)
-	flag.Parse()
+	opts := nodeFlags(flag.CommandLine)
+	_ = flag.CommandLine.Parse(args) // exits on error
+
+	if *opts.workDir != "" {
+		if err := os.Chdir(*opts.workDir); err != nil {
+			log.Fatal("failed to change to working directory:", err)
+		}
+	}
 
 	// Configure structured logging
-	logging.ConfigureLogger(*logLevel)
+	if *opts.logFile != "" {
+		f, err := os.OpenFile(*opts.logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatal("failed to open log file:", err)
+		}
+		defer f.Close()
+		log.SetOutput(f)
+		logging.ConfigureLoggerOutput(*opts.logLevel, f)
+	} else {
+		logging.ConfigureLogger(*opts.logLevel)
+	}
 	slog.Info("starting PeerVault node",
-		"listen_addr", *listenAddr,
-		"bootstrap_nodes", *bootstrapNodes,
-		"log_level", *logLevel)
+		"listen_addr", *opts.listenAddr,
+		"bootstrap_nodes", *opts.bootstrapNodes,
+		"log_level", *opts.logLevel)
+
+	bootstrapList := splitAddrs(*opts.bootstrapNodes)
 
-	// Parse bootstrap nodes
-	var bootstrapList []string
-	if *bootstrapNodes != "" {
-		bootstrapList = strings.Split(*bootstrapNodes, ",")
-		// Trim whitespace from each address
-		for i, addr := range bootstrapList {
-			bootstrapList[i] = strings.TrimSpace(addr)
+	// Run until SIGTERM/SIGINT or, on Windows, a service stop request
+	err := service.Run(serviceName, func(stop <-chan struct{}) error {
+		// The file server records file attributes in the metadata store,
+		// kept in memory when the node runs no metadata service
+		meta := metadata.NewStore(metadata.StoreOpts{})
+		if *opts.metadataAddr != "" {
+			var err error
+			if meta, err = startMetadataService(opts); err != nil {
+				return err
+			}
+		}
+
+		// Create server
+		server, err := makeServer(*opts.listenAddr, *opts.storagePrefix, *opts.storageHash, *opts.versionsPath, meta, bootstrapList...)
+		if err != nil {
+			return err
 		}
-	}
 
-	// Create server
-	server := makeServer(*listenAddr, *storagePrefix, bootstrapList...)
+		if *opts.raftAddr != "" {
+			group, err := startConsensus(opts)
+			if err != nil {
+				return err
+			}
+			defer group.Stop()
+		}
 
-	// Start the server
-	slog.Info("starting PeerVault node server", "address", *listenAddr)
-	if err := server.Start(); err != nil {
-		log.Fatal("failed to start server:", err)
+		if *opts.storageAdmin != "" || *opts.migrateStorage {
+			if err := startStorageMigration(server.Storage(), *opts.storageAdmin, *opts.storageToken, *opts.migrateStorage); err != nil {
+				return err
+			}
+		}
+
+		diag, err := diagnostics.Start(*opts.diagnostics)
+		if err != nil {
+			return err
+		}
+		defer diag.Shutdown(context.Background())
+
+		// Start the server
+		slog.Info("starting PeerVault node server", "address", *opts.listenAddr)
+		if err := server.Start(); err != nil {
+			return fmt.Errorf("failed to start server: %w", err)
+		}
+
+		<-stop
+		slog.Info("stopping PeerVault node")
+		server.Stop()
+		return nil
+	})
+	if err != nil {
+		slog.Error("PeerVault node failed", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("PeerVault node stopped")
+}
+
+// splitAddrs parses a comma-separated list of addresses
+func splitAddrs(list string) []string {
+	if list == "" {
+		return nil
 	}
+	addrs := strings.Split(list, ",")
+	// Trim whitespace from each address
+	for i, addr := range addrs {
+		addrs[i] = strings.TrimSpace(addr)
+	}
+	return addrs
 }
 
-func makeServer(listenAddr, storagePrefix string, bootstrapNodes ...string) *fs.Server {
-	// Generate a unique node ID for this server
-	nodeID := crypto.GenerateID()
+// makeServer creates the file server of the node; meta may be nil when the
+// server records no file attributes
+func makeServer(listenAddr, storagePrefix, storageHash, versionsPath string, meta *metadata.Store, bootstrapNodes ...string) (*fs.Server, error) {
+	hash, err := storage.ParseHashAlgorithm(storageHash)
+	if err != nil {
+		return nil, err
+	}
+	// Create storage root with prefix for better organization in containers
+	storageRoot := storage.SanitizeStorageRootFromAddrWithPrefix(listenAddr, storagePrefix)
+
+	// The node keeps its identity, and so its ID, across restarts
+	identity, err := crypto.LoadOrCreateIdentity(filepath.Join(storageRoot, storage.IdentityFileName))
+	if err != nil {
+		return nil, err
+	}
+	nodeID := identity.ID()
 
 	tcptransportOpts := netp2p.TCPTransportOpts{
 		ListenAddr:    listenAddr,
-		HandshakeFunc: netp2p.AuthenticatedHandshakeFunc(nodeID),
+		HandshakeFunc: netp2p.IdentityHandshakeFunc(identity),
 		Decoder:       netp2p.LengthPrefixedDecoder{},
 	}
 	tcpTransport := netp2p.NewTCPTransport(tcptransportOpts)
 
-	// Create storage root with prefix for better organization in containers
-	storageRoot := storage.SanitizeStorageRootFromAddrWithPrefix(listenAddr, storagePrefix)
-
 	fileServerOpts := fs.Options{
-		ID:                nodeID,
-		EncKey:            crypto.NewEncryptionKey(),
-		StorageRoot:       storageRoot,
-		PathTransformFunc: storage.CASPathTransformFunc,
-		Transport:         tcpTransport,
-		BootstrapNodes:    bootstrapNodes,
-		ResourceLimits:    peer.DefaultResourceLimits(),
+		ID:             nodeID,
+		EncKey:         crypto.NewEncryptionKey(),
+		StorageRoot:    storageRoot,
+		StorageHash:    hash,
+		Transport:      tcpTransport,
+		BootstrapNodes: bootstrapNodes,
+		ResourceLimits: peer.DefaultResourceLimits(),
+		VersionsPath:   versionsPath,
+		Metadata:       meta,
 	}
 	s := fs.New(fileServerOpts)
 	tcpTransport.OnPeer = s.OnPeer
-	return s
+	return s, nil
+}
+
+// startMetadataService runs the metadata store as a primary or warm standby
+// and returns it. A standby must share its fence with the primary: with
+// the in-memory default, promoting it would not stop the old primary.
+func startMetadataService(opts *nodeOptions) (*metadata.Store, error) {
+	role, primaryURL, fencePath := metadata.Role(*opts.metadataRole), *opts.metadataFrom, *opts.metadataFence
+	if *opts.metadataToken == "" {
+		return nil, fmt.Errorf("metadata service requires -metadata-token or $%s", metadata.TokenEnv)
+	}
+	if role == metadata.RoleStandby {
+		if primaryURL == "" {
+			return nil, errors.New("metadata standby requires -metadata-primary")
+		}
+		if fencePath == "" {
+			return nil, errors.New("metadata standby requires -metadata-fence naming the fence shared with the primary")
+		}
+	}
+
+	var fence metadata.Fence = metadata.NewMemoryFence()
+	if fencePath != "" {
+		fence = metadata.NewFileFence(fencePath)
+	} else {
+		slog.Warn("metadata primary has no shared fence; a promoted standby cannot fence it", "flag", "-metadata-fence")
+	}
+
+	store := metadata.NewStore(metadata.StoreOpts{Role: role, Fence: fence})
+
+	var standby *metadata.Standby
+	if role == metadata.RoleStandby {
+		standby = metadata.NewStandby(metadata.StandbyOpts{
+			PrimaryURL: primaryURL,
+			AuthToken:  *opts.metadataToken,
+			Store:      store,
+			Fence:      fence,
+		})
+		standby.Start()
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metadata/replication", metadata.ReplicationHandler(store, time.Second, *opts.metadataToken))
+	mux.Handle("/metadata/", metadata.AdminHandler(store, standby, *opts.metadataToken))
+
+	go func() {
+		slog.Info("metadata service listening", "addr", *opts.metadataAddr, "role", role)
+		if err := http.ListenAndServe(*opts.metadataAddr, mux); err != nil {
+			slog.Error("metadata service stopped", "error", err)
+		}
+	}()
+	return store, nil
+}
+
+// startConsensus joins the Raft group holding the authoritative cluster
+// metadata and registers this node as a member once a leader is elected
+func startConsensus(opts *nodeOptions) (*consensus.Node, error) {
+	peers, err := consensus.ParsePeers(*opts.raftPeers)
+	if err != nil {
+		return nil, err
+	}
+	dir := *opts.raftDir
+	if dir == "" {
+		dir = *opts.storagePrefix + "_raft"
+	}
+
+	state := consensus.NewClusterState()
+	node, err := consensus.NewNode(consensus.Options{
+		ID:        *opts.raftID,
+		Peers:     peers,
+		Dir:       dir,
+		AuthToken: *opts.raftToken,
+	}, state)
+	if err != nil {
+		return nil, err
+	}
+	node.Start()
+
+	go func() {
+		slog.Info("raft group listening", "addr", *opts.raftAddr, "id", *opts.raftID, "members", len(peers))
+		if err := http.ListenAndServe(*opts.raftAddr, consensus.Handler(node, state)); err != nil {
+			slog.Error("raft group stopped", "error", err)
+		}
+	}()
+
+	go func() {
+		member, _ := json.Marshal(consensus.Command{
+			Type:   consensus.CommandPutMember,
+			Time:   time.Now().UTC(),
+			Member: &consensus.Member{ID: *opts.raftID, Addr: *opts.listenAddr},
+		})
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_, err := node.Submit(ctx, member)
+			cancel()
+			if err == nil || errors.Is(err, consensus.ErrStopped) {
+				return
+			}
+			time.Sleep(time.Second)
+		}
+	}()
+	return node, nil
+}
+
+// startStorageMigration exposes storage migration controls and optionally
+// starts migrating to the chunked format while the node serves traffic.
+// The controls require a token, and listen on loopback unless addr names
+// another host.
+func startStorageMigration(store *storage.Store, addr, token string, start bool) error {
+	if addr != "" {
+		if token == "" {
+			return fmt.Errorf("%w: set -storage-admin-token or $%s", storage.ErrNoMigrationToken, storage.MigrationTokenEnv)
+		}
+		var err error
+		if addr, err = storage.MigrationAddr(addr); err != nil {
+			return err
+		}
+	}
+	migrator, err := storage.NewMigrator(store, storage.MigratorOpts{Throttle: 5 * time.Millisecond})
+	if err != nil {
+		return fmt.Errorf("failed to load storage migration state: %w", err)
+	}
+
+	if start {
+		if err := migrator.Start(); err != nil {
+			slog.Warn("storage migration not started", "error", err)
+		}
+	}
+
+	if addr == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("storage admin: %w", err)
+	}
+	go func() {
+		slog.Info("storage admin listening", "addr", listener.Addr().String(), "format", store.Format())
+		if err := http.Serve(listener, storage.MigrationHandler(migrator, token)); err != nil {
+			slog.Error("storage admin stopped", "error", err)
+		}
+	}()
+	return nil
 }
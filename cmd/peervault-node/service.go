@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+
+	"github.com/Skpow1234/Peervault/internal/service"
+)
+
+// serviceCommand handles install, uninstall, start, stop and status. Node
+// flags for install follow "--" and are checked before anything is
+// registered:
+//
+//	peervault-node install -user peervault -- -listen :3000 -bootstrap node1:3000
+func serviceCommand(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	name := fs.String("name", serviceName, "Service name")
+	var (
+		workDir *string
+		logFile *string
+		user    *string
+	)
+	if cmd == "install" {
+		workDir = fs.String("workdir", defaultDataDir(), "Directory the node keeps its storage in")
+		logFile = fs.String("log-file", "", "Log file (default <workdir>/logs/<name>.log)")
+		user = fs.String("user", "", "Run the service as this user (systemd only)")
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "Usage: peervault-node install [options] [-- node flags]\n")
+			fs.PrintDefaults()
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	manager, err := service.New()
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "install":
+		return install(manager, *name, *workDir, *logFile, *user, fs.Args())
+	case "uninstall":
+		if err := manager.Uninstall(*name); err != nil {
+			return err
+		}
+		fmt.Printf("Uninstalled %s; its data was left in place\n", *name)
+	case "start":
+		if err := manager.Start(*name); err != nil {
+			return err
+		}
+		fmt.Printf("Started %s\n", *name)
+	case "stop":
+		if err := manager.Stop(*name); err != nil {
+			return err
+		}
+		fmt.Printf("Stopped %s\n", *name)
+	case "status":
+		status, err := manager.Status(*name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s\n", *name, status)
+		if status == service.StatusNotInstalled {
+			os.Exit(3)
+		}
+	}
+	return nil
+}
+
+func install(manager service.Manager, name, workDir, logFile, username string, nodeArgs []string) error {
+	// Catch typos now rather than in a restart loop
+	check := flag.NewFlagSet("node", flag.ContinueOnError)
+	check.SetOutput(io.Discard)
+	nodeFlags(check)
+	if err := check.Parse(nodeArgs); err != nil {
+		return fmt.Errorf("invalid node flags: %w", err)
+	}
+	if check.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q; node flags go after --", check.Arg(0))
+	}
+	for _, f := range []string{"workdir", "log-file"} {
+		if check.Lookup(f).Value.String() != "" {
+			return fmt.Errorf("-%s is an install option; pass it before --", f)
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	if workDir, err = filepath.Abs(workDir); err != nil {
+		return err
+	}
+	if logFile == "" {
+		logFile = filepath.Join(workDir, "logs", name+".log")
+	}
+	if logFile, err = filepath.Abs(logFile); err != nil {
+		return err
+	}
+	for _, dir := range []string{workDir, filepath.Dir(logFile)} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return err
+		}
+		if err := chownTo(dir, username); err != nil {
+			return err
+		}
+	}
+
+	args := []string{"run", "-workdir", workDir}
+	if runtime.GOOS == "windows" {
+		// Windows services have no output to redirect, so the node writes
+		// the file itself
+		args = append(args, "-log-file", logFile)
+	}
+	cfg := service.Config{
+		Name:        name,
+		DisplayName: "PeerVault Node (" + name + ")",
+		Description: "PeerVault peer-to-peer storage node",
+		Executable:  exe,
+		Args:        append(args, nodeArgs...),
+		WorkingDir:  workDir,
+		User:        username,
+		LogFile:     logFile,
+	}
+	if err := manager.Install(cfg); err != nil {
+		if errors.Is(err, service.ErrAlreadyInstalled) {
+			return fmt.Errorf("%w; run 'peervault-node uninstall -name %s' first", err, name)
+		}
+		return err
+	}
+	fmt.Printf("Installed %s (data in %s, logs in %s)\n", name, workDir, logFile)
+	fmt.Printf("Start it with: peervault-node start -name %s\n", name)
+	return nil
+}
+
+// defaultDataDir is where installed nodes keep their storage
+func defaultDataDir() string {
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("ProgramData"); dir != "" {
+			return filepath.Join(dir, "PeerVault")
+		}
+		return `C:\ProgramData\PeerVault`
+	}
+	return "/var/lib/peervault"
+}
+
+// chownTo hands a directory the installer created to the service user
+func chownTo(dir, username string) error {
+	if username == "" || runtime.GOOS == "windows" {
+		return nil
+	}
+	u, err := user.Lookup(username)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return err
+	}
+	return os.Chown(dir, uid, gid)
+}
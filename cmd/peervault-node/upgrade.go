@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Skpow1234/Peervault/internal/logging"
+)
+
+// upgradeCommand upgrades the on-disk layout of a stopped node, which the
+// node otherwise does when it starts, and prints the report as JSON. It
+// takes the node's own flags, so it opens the same store:
+//
+//	peervault-node upgrade -dry-run -listen :3000 -versions versions.json
+func upgradeCommand(args []string) error {
+	fs := flag.NewFlagSet("upgrade", flag.ContinueOnError)
+	opts := nodeFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "Report the upgrade steps that would run without changing anything")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: peervault-node upgrade [-dry-run] [node flags]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *opts.workDir != "" {
+		if err := os.Chdir(*opts.workDir); err != nil {
+			return fmt.Errorf("failed to change to working directory: %w", err)
+		}
+	}
+	// Standard output carries the report
+	logging.ConfigureLoggerOutput(*opts.logLevel, os.Stderr)
+
+	server, err := makeServer(*opts.listenAddr, *opts.storagePrefix, *opts.storageHash, *opts.versionsPath, nil)
+	if err != nil {
+		return err
+	}
+	report, err := server.UpgradeLayout(*dryRun)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
@@ -0,0 +1,65 @@
+// peervault-rendezvous runs the rendezvous service PeerVault nodes register
+// with to find each other. Nodes point network.rendezvous.url at it:
+//
+//	peervault-rendezvous -listen :7070 -token secret
+//
+// Registrations are kept in memory; nodes register again within a minute,
+// so a restarted service is filled again quickly.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/logging"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/rendezvous"
+)
+
+func main() {
+	listen := flag.String("listen", ":7070", "Address to serve the rendezvous API on")
+	token := flag.String("token", os.Getenv("PEERVAULT_RENDEZVOUS_TOKEN"), "Bearer token nodes must send (PEERVAULT_RENDEZVOUS_TOKEN)")
+	ttl := flag.Duration("ttl", rendezvous.DefaultTTL, "How long registrations last unless renewed")
+	maxPeers := flag.Int("max-peers", rendezvous.DefaultMaxPeers, "Most nodes registered per cluster")
+	logLevel := flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+	flag.Parse()
+
+	logging.ConfigureLogger(*logLevel)
+	logger := slog.Default()
+	if *token == "" {
+		logger.Warn("No token configured, anyone reaching the service can register nodes")
+	}
+
+	server := &http.Server{
+		Addr: *listen,
+		Handler: rendezvous.NewServer(rendezvous.ServerOptions{
+			Token:    *token,
+			TTL:      *ttl,
+			MaxPeers: *maxPeers,
+			Logger:   logger,
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("Starting rendezvous service", "addr", *listen)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintln(os.Stderr, "peervault-rendezvous:", err)
+		os.Exit(1)
+	}
+}
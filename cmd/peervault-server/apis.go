@@ -0,0 +1,655 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/api/coap"
+	"github.com/Skpow1234/Peervault/internal/api/gateway"
+	"github.com/Skpow1234/Peervault/internal/api/graphql"
+	"github.com/Skpow1234/Peervault/internal/api/grpc"
+	"github.com/Skpow1234/Peervault/internal/api/grpc/interceptors"
+	"github.com/Skpow1234/Peervault/internal/api/httpguard"
+	"github.com/Skpow1234/Peervault/internal/api/kafka"
+	"github.com/Skpow1234/Peervault/internal/api/mqtt"
+	"github.com/Skpow1234/Peervault/internal/api/nfs"
+	"github.com/Skpow1234/Peervault/internal/api/rest"
+	restgateway "github.com/Skpow1234/Peervault/internal/api/rest/gateway"
+	"github.com/Skpow1234/Peervault/internal/api/rest/ratelimit"
+	"github.com/Skpow1234/Peervault/internal/api/rest/registry"
+	"github.com/Skpow1234/Peervault/internal/api/sftp"
+	"github.com/Skpow1234/Peervault/internal/api/sse"
+	"github.com/Skpow1234/Peervault/internal/api/websocket"
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/autotls"
+	"github.com/Skpow1234/Peervault/internal/config"
+	"github.com/Skpow1234/Peervault/internal/consensus"
+	"github.com/Skpow1234/Peervault/internal/devices"
+	"github.com/Skpow1234/Peervault/internal/diagnostics"
+	"github.com/Skpow1234/Peervault/internal/geo"
+	"github.com/Skpow1234/Peervault/internal/georeplication"
+	"github.com/Skpow1234/Peervault/internal/jsondoc"
+	"github.com/Skpow1234/Peervault/internal/media"
+	"github.com/Skpow1234/Peervault/internal/plugins"
+	"github.com/Skpow1234/Peervault/internal/pubsub"
+	"github.com/Skpow1234/Peervault/internal/retention"
+	"github.com/Skpow1234/Peervault/internal/sharing"
+	"github.com/Skpow1234/Peervault/internal/streamlog"
+	"golang.org/x/crypto/ssh"
+)
+
+// api is one protocol server mounted on the shared node. serve blocks until
+// the API stops; stop shuts it down gracefully and may be nil for servers
+// that stop when ctx is cancelled.
+type api struct {
+	name  string
+	addr  string
+	serve func(ctx context.Context) error
+	stop  func(ctx context.Context) error
+}
+
+// enabledAPIs builds the servers turned on in the config, all backed by node
+func enabledAPIs(cfg *config.Config, node *fs.Server, locks *retention.Manager, logger *slog.Logger) ([]api, error) {
+	var apis []api
+	c := cfg.API
+
+	// Every HTTP API shares the certificates when TLS is on
+	certs, err := serverTLS(cfg.Security)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := certs.Config
+	if certs != nil && certs.ACME() && cfg.Security.ACME.HTTPAddr != "" {
+		server := &http.Server{
+			Addr:              cfg.Security.ACME.HTTPAddr,
+			Handler:           certs.HTTPHandler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		apis = append(apis, api{
+			name:  "ACME HTTP-01",
+			addr:  server.Addr,
+			serve: func(context.Context) error { return ignoreClosed(server.ListenAndServe()) },
+			stop:  server.Shutdown,
+		})
+	}
+
+	// The REST and gRPC APIs serve the locks and leases of the Raft group
+	var cluster *consensus.Client
+	if len(cfg.Consensus.Members) > 0 {
+		if cluster, err = consensus.NewClient(cfg.Consensus.Members, cfg.Consensus.Token, nil); err != nil {
+			logger.Error("Failed to reach the Raft group, lease APIs disabled", "error", err)
+		}
+	}
+
+	security := httpguard.Policy{
+		CSRF:                  c.HTTPSecurity.CSRFProtection,
+		HSTSMaxAge:            c.HTTPSecurity.HSTSMaxAge,
+		ContentSecurityPolicy: c.HTTPSecurity.ContentSecurityPolicy,
+		PreflightMaxAge:       c.HTTPSecurity.PreflightMaxAge,
+	}
+
+	if c.REST.Enabled {
+		restConfig := rest.DefaultConfig()
+		restConfig.Port = fmt.Sprintf(":%d", c.REST.Port)
+		restConfig.AllowedOrigins = c.REST.AllowedOrigins
+		restConfig.Security = security
+		restConfig.RateLimitPerMin = c.REST.RateLimitPerMin
+		restConfig.AuthToken = c.REST.AuthToken
+		for _, p := range plugins.ListExternal() {
+			if auth := p.Auth(); auth != nil {
+				restConfig.AuthProviders = append(restConfig.AuthProviders, auth)
+			}
+		}
+		restConfig.Retention = locks
+		restConfig.FileServer = node
+		restConfig.Cluster = cluster
+		restConfig.TLSConfig = tlsConfig()
+		if m := cfg.Media; m.Enabled {
+			restConfig.Media = &media.Config{
+				Sizes:          m.Sizes,
+				OnIngest:       m.OnIngest,
+				FFmpeg:         m.FFmpeg,
+				FFprobe:        m.FFprobe,
+				Quality:        m.Quality,
+				Timeout:        m.Timeout,
+				SegmentSeconds: m.SegmentSeconds,
+			}
+			for _, p := range m.Profiles {
+				restConfig.Media.Profiles = append(restConfig.Media.Profiles, media.Profile{
+					Name:         p.Name,
+					Height:       p.Height,
+					VideoBitrate: p.VideoBitrate,
+					AudioBitrate: p.AudioBitrate,
+					Args:         p.Args,
+				})
+			}
+		}
+		if cfg.JSON.Schemas != "" {
+			schemas, err := jsondoc.LoadConfig(cfg.JSON.Schemas)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load JSON schemas: %w", err)
+			}
+			restConfig.JSONSchemas = schemas
+		}
+		if cfg.Logs.Enabled {
+			logs := logsConfig(cfg.Logs)
+			restConfig.Logs = &logs
+		}
+		if c.NFS.Enabled {
+			restConfig.NFS = nfsConfig(c.NFS)
+		}
+		if c.SFTP.Enabled {
+			if restConfig.SFTP, err = sftpConfig(c.SFTP); err != nil {
+				return nil, err
+			}
+		}
+		if c.Registry.Enabled {
+			restConfig.Registry = &registry.Config{
+				Root:            c.Registry.Root,
+				MaxBlobSize:     c.Registry.MaxBlobSize,
+				MaxManifestSize: c.Registry.MaxManifestSize,
+			}
+		}
+		server := rest.NewServer(restConfig, logger)
+		apis = append(apis, api{
+			name:  "REST",
+			addr:  restConfig.Port,
+			serve: func(context.Context) error { return ignoreClosed(server.Start()) },
+			stop:  server.Stop,
+		})
+		if server.NFS != nil {
+			addr := fmt.Sprintf(":%d", c.NFS.Port)
+			apis = append(apis, api{
+				name: "NFS",
+				addr: addr,
+				serve: func(ctx context.Context) error {
+					listener, err := net.Listen("tcp", addr)
+					if err != nil {
+						return err
+					}
+					defer listener.Close()
+					return server.NFS.Serve(ctx, listener)
+				},
+			})
+		}
+		if server.SFTP != nil {
+			addr := fmt.Sprintf(":%d", c.SFTP.Port)
+			apis = append(apis, api{
+				name: "SFTP",
+				addr: addr,
+				serve: func(ctx context.Context) error {
+					listener, err := net.Listen("tcp", addr)
+					if err != nil {
+						return err
+					}
+					defer listener.Close()
+					return server.SFTP.Serve(ctx, listener)
+				},
+			})
+		}
+	}
+
+	if c.GraphQL.Enabled {
+		graphqlConfig := graphql.DefaultConfig()
+		graphqlConfig.Port = c.GraphQL.Port
+		graphqlConfig.GraphQLPath = c.GraphQL.GraphQLPath
+		graphqlConfig.PlaygroundPath = c.GraphQL.PlaygroundPath
+		graphqlConfig.EnablePlayground = c.GraphQL.EnablePlayground
+		graphqlConfig.AllowedOrigins = c.GraphQL.AllowedOrigins
+		graphqlConfig.Security = security
+		graphqlConfig.TLSConfig = tlsConfig()
+		server := graphql.NewServer(node, graphqlConfig)
+		apis = append(apis, api{
+			name:  "GraphQL",
+			addr:  fmt.Sprintf(":%d", c.GraphQL.Port),
+			serve: func(context.Context) error { return server.Start(graphqlConfig) },
+			stop:  func(context.Context) error { return server.Stop() },
+		})
+	}
+
+	if c.GRPC.Enabled {
+		chain := &interceptors.ChainConfig{Recovery: true}
+		if c.GRPC.LogRequests {
+			chain.Logging = interceptors.DefaultLoggingConfig()
+		}
+		if c.GRPC.RequireAuth {
+			chain.Auth = interceptors.DefaultAuthConfig()
+			chain.Auth.SecretKey = c.GRPC.JWTSecret
+			chain.Auth.Tokens = map[string]string{c.GRPC.AuthToken: "token"}
+		}
+		if c.GRPC.RateLimit > 0 {
+			chain.RateLimit = &interceptors.RateLimitConfig{RequestsPerSecond: c.GRPC.RateLimit, Burst: c.GRPC.RateLimitBurst}
+		}
+		server := grpc.NewServer(&grpc.Config{
+			Port:             fmt.Sprintf(":%d", c.GRPC.Port),
+			AuthToken:        c.GRPC.AuthToken,
+			Cluster:          cluster,
+			Messaging:        node.Messaging,
+			Tasks:            node.Tasks,
+			EnableReflection: c.GRPC.EnableReflection,
+			EnableChannelz:   c.GRPC.EnableChannelz,
+			Interceptors:     chain,
+			TLSConfig:        tlsConfig(),
+			Logs:             logsConfig(cfg.Logs),
+		}, logger)
+		apis = append(apis, api{
+			name:  "gRPC",
+			addr:  fmt.Sprintf(":%d", c.GRPC.Port),
+			serve: func(context.Context) error { return ignoreClosed(server.Start()) },
+			// Stop closes the listener Shutdown already closed
+			stop: func(context.Context) error { return ignoreClosed(server.Stop()) },
+		})
+	}
+
+	if c.WebSocket.Enabled {
+		wsConfig := websocket.DefaultConfig()
+		wsConfig.Port = c.WebSocket.Port
+		wsConfig.AllowedOrigins = c.WebSocket.AllowedOrigins
+		wsConfig.Security = security
+		apis = append(apis, httpAPI("WebSocket", c.WebSocket.Port, websocket.NewServer(node, wsConfig, logger), wsConfig.ReadTimeout, wsConfig.WriteTimeout, tlsConfig()))
+	}
+
+	if c.SSE.Enabled {
+		sseConfig := sse.DefaultConfig()
+		sseConfig.Port = c.SSE.Port
+		sseConfig.AllowedOrigins = c.SSE.AllowedOrigins
+		sseConfig.Security = security
+		sseConfig.HistorySize = c.SSE.HistorySize
+		apis = append(apis, httpAPI("SSE", c.SSE.Port, sse.NewServer(node, sseConfig, logger), sseConfig.ReadTimeout, sseConfig.WriteTimeout, tlsConfig()))
+	}
+
+	if c.MQTT.Enabled {
+		broker := mqtt.NewBroker(node, &mqtt.BrokerConfig{
+			Port:            c.MQTT.Port,
+			EnableWebSocket: c.MQTT.EnableWebSocket,
+			WebSocketPort:   c.MQTT.WebSocketPort,
+			KeepAlive:       60 * time.Second,
+			MaxConnections:  1000,
+			MaxMessageSize:  256 * 1024, // 256KB
+			RetainEnabled:   true,
+			WillEnabled:     true,
+			CleanSession:    true,
+			WebSocketTLS:    tlsConfig(),
+			TLS:             mqttTLS(c.MQTT, node, tlsConfig()),
+			ACL:             node.TopicACL,
+			Firmware:        node.Firmware,
+			Telemetry:       node.Telemetry,
+		}, logger)
+		addr := fmt.Sprintf(":%d", c.MQTT.Port)
+		apis = append(apis, api{
+			name: "MQTT",
+			addr: addr,
+			serve: func(ctx context.Context) error {
+				listener, err := net.Listen("tcp", addr)
+				if err != nil {
+					return err
+				}
+				defer listener.Close()
+				return broker.ServeTCP(ctx, listener)
+			},
+		})
+		if c.MQTT.EnableWebSocket {
+			wsAddr := fmt.Sprintf(":%d", c.MQTT.WebSocketPort)
+			apis = append(apis, api{
+				name: "MQTT over WebSocket",
+				addr: wsAddr,
+				serve: func(ctx context.Context) error {
+					return ignoreClosed(broker.ServeWebSocket(ctx, wsAddr))
+				},
+			})
+		}
+	}
+
+	if c.Kafka.Enabled {
+		kafkaConfig := kafka.DefaultConfig()
+		kafkaConfig.Prefix = c.Kafka.Prefix
+		kafkaConfig.AdvertisedAddr = c.Kafka.AdvertisedAddr
+		server := kafka.NewServer(node, kafkaConfig, logger)
+		addr := fmt.Sprintf(":%d", c.Kafka.Port)
+		apis = append(apis, api{
+			name: "Kafka",
+			addr: addr,
+			serve: func(ctx context.Context) error {
+				listener, err := net.Listen("tcp", addr)
+				if err != nil {
+					return err
+				}
+				defer listener.Close()
+				return server.Serve(ctx, listener)
+			},
+		})
+	}
+
+	if c.CoAP.Enabled {
+		server := coap.NewServer(node, &coap.ServerConfig{
+			Port:           c.CoAP.Port,
+			MaxConnections: 1000,
+			MaxMessageSize: 1024, // CoAP is designed for small messages
+			BlockSize:      64,
+			MaxAge:         60,
+			EnableObserve:  true,
+			ObserveTimeout: 30 * time.Second,
+		}, logger)
+		addr := fmt.Sprintf(":%d", c.CoAP.Port)
+		apis = append(apis, api{
+			name: "CoAP",
+			addr: addr + "/udp",
+			serve: func(ctx context.Context) error {
+				udpAddr, err := net.ResolveUDPAddr("udp", addr)
+				if err != nil {
+					return err
+				}
+				conn, err := net.ListenUDP("udp", udpAddr)
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+				return server.ServeUDP(ctx, conn)
+			},
+		})
+	}
+
+	var router *geo.Router
+	if cfg.Geo.Enabled || cfg.Geo.DNSName != "" {
+		if router, err = geoRouter(cfg.Geo, node); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Gateway.Enabled {
+		var redirect *geo.Router
+		if cfg.Geo.Enabled {
+			redirect = router
+		}
+		gw, err := apiGateway(cfg, certs, redirect, logger)
+		if err != nil {
+			return nil, err
+		}
+		apis = append(apis, api{
+			name:  "API gateway",
+			addr:  fmt.Sprintf(":%d", c.Gateway.Port),
+			serve: func(context.Context) error { return ignoreClosed(gw.Start()) },
+			stop:  gw.Stop,
+		})
+	}
+
+	if cfg.Geo.DNSName != "" {
+		server, err := geo.NewDNSServer(geo.DNSOptions{Name: cfg.Geo.DNSName, TTL: cfg.Geo.DNSTTL, Router: router, Logger: logger})
+		if err != nil {
+			return nil, err
+		}
+		addr := fmt.Sprintf(":%d", cfg.Geo.DNSPort)
+		apis = append(apis, api{
+			name: "GeoDNS",
+			addr: addr + "/udp",
+			serve: func(ctx context.Context) error {
+				conn, err := net.ListenPacket("udp", addr)
+				if err != nil {
+					return err
+				}
+				return server.ServeUDP(ctx, conn)
+			},
+		})
+	}
+
+	if cfg.Diagnostics.Enabled {
+		server := diagnostics.NewHTTPServer(cfg.Diagnostics.Addr, cfg.Diagnostics.Token)
+		server.TLSConfig = tlsConfig()
+		apis = append(apis, api{
+			name:  "diagnostics",
+			addr:  server.Addr,
+			serve: func(context.Context) error { return ignoreClosed(listenAndServe(server)) },
+			stop:  server.Shutdown,
+		})
+	}
+
+	return apis, nil
+}
+
+// apiGateway builds the gateway serving the node's HTTP APIs on one port.
+// Paths are routed as the APIs serve them, except GraphQL subscriptions,
+// which move to <graphql path>/ws next to the WebSocket API's /ws, and the
+// federation gateway, which is served under /federation/.
+func apiGateway(cfg *config.Config, certs *autotls.TLS, router *geo.Router, logger *slog.Logger) (*gateway.Gateway, error) {
+	c := cfg.API
+	scheme := "http"
+	if certs != nil {
+		scheme = "https"
+	}
+	local := func(port int) string { return fmt.Sprintf("%s://127.0.0.1:%d", scheme, port) }
+
+	upstreams := map[string]string{}
+	var routes []gateway.RouteConfig
+	if c.REST.Enabled {
+		upstreams["rest"] = local(c.REST.Port)
+		routes = append(routes, gateway.RouteConfig{Path: "/", UpstreamURL: upstreams["rest"], PreserveHost: true})
+	}
+	if c.GraphQL.Enabled {
+		upstreams["graphql"] = local(c.GraphQL.Port)
+		routes = append(routes,
+			gateway.RouteConfig{Path: c.GraphQL.GraphQLPath, UpstreamURL: upstreams["graphql"], PreserveHost: true},
+			gateway.RouteConfig{Path: c.GraphQL.PlaygroundPath, UpstreamURL: upstreams["graphql"], PreserveHost: true},
+			gateway.RouteConfig{
+				Path:         c.GraphQL.GraphQLPath + "/ws",
+				UpstreamURL:  upstreams["graphql"],
+				RewriteRules: []gateway.RewriteRule{{Pattern: c.GraphQL.GraphQLPath + "/ws", Replace: graphql.DefaultConfig().WebSocketPath}},
+				PreserveHost: true,
+			})
+	}
+	if c.WebSocket.Enabled {
+		upstreams["websocket"] = local(c.WebSocket.Port)
+		routes = append(routes,
+			gateway.RouteConfig{Path: "/ws", UpstreamURL: upstreams["websocket"], PreserveHost: true},
+			gateway.RouteConfig{Path: "/ws/", UpstreamURL: upstreams["websocket"], PreserveHost: true})
+	}
+	if c.SSE.Enabled {
+		upstreams["sse"] = local(c.SSE.Port)
+		routes = append(routes,
+			gateway.RouteConfig{Path: "/sse", UpstreamURL: upstreams["sse"], PreserveHost: true},
+			gateway.RouteConfig{Path: "/sse/", UpstreamURL: upstreams["sse"], PreserveHost: true})
+	}
+	if c.Gateway.FederationURL != "" {
+		upstreams["federation"] = c.Gateway.FederationURL
+		routes = append(routes, gateway.RouteConfig{Path: "/federation/", UpstreamURL: c.Gateway.FederationURL, StripPrefix: "/federation"})
+	}
+	for name, host := range c.Gateway.Hosts {
+		upstream, ok := upstreams[name]
+		if !ok {
+			return nil, fmt.Errorf("gateway host %s routes to %s, which is not enabled", host, name)
+		}
+		routes = append(routes, gateway.RouteConfig{Host: host, Path: "/", UpstreamURL: upstream, PreserveHost: name != "federation"})
+	}
+
+	gatewayConfig := &gateway.GatewayConfig{
+		Name:       "peervault",
+		ListenAddr: fmt.Sprintf(":%d", c.Gateway.Port),
+		// Bounds buffered requests only; streams are relayed without one
+		UpstreamTimeout: 5 * time.Minute,
+		Routes:          routes,
+		AuthToken:       c.Gateway.AuthToken,
+		PublicPaths: []string{
+			"/health", "/api", "/docs", "/swagger.json", "/ws/health", "/sse/health",
+			c.GraphQL.PlaygroundPath, sharing.PathPrefix, restgateway.PathPrefix, georeplication.Path, devices.ESTPath + "/",
+		},
+		TLSConfig: certs.Config(),
+	}
+	if certs != nil {
+		// The upstreams are this node's own servers, reached over loopback
+		// under a name their certificates may not carry
+		gatewayConfig.UpstreamTLS = &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS12}
+		if len(cfg.Security.ACME.Domains) > 0 {
+			gatewayConfig.UpstreamTLS.ServerName = cfg.Security.ACME.Domains[0]
+		}
+	}
+	if c.Gateway.RateLimitPerMin > 0 {
+		rateLimit := ratelimit.DefaultConfig()
+		rateLimit.RequestsPerMin = c.Gateway.RateLimitPerMin
+		rateLimit.BurstSize = max(c.Gateway.RateLimitPerMin/10, 1)
+		gatewayConfig.RateLimitConfig = rateLimit
+	}
+	if router != nil {
+		gatewayConfig.Router = router
+	}
+	return gateway.NewGateway(gatewayConfig, logger)
+}
+
+// geoRouter locates clients with the configured GeoIP database and
+// networks and chooses among the healthy nodes of the cluster
+func geoRouter(cfg config.GeoConfig, node *fs.Server) (*geo.Router, error) {
+	db := geo.NewDB()
+	if cfg.Database != "" {
+		var err error
+		if db, err = geo.OpenCSV(cfg.Database); err != nil {
+			return nil, fmt.Errorf("failed to load GeoIP database: %w", err)
+		}
+	}
+	for network, region := range cfg.Networks {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return nil, fmt.Errorf("invalid geo network %q: %w", network, err)
+		}
+		db.AddNetwork(prefix, geo.Location{Region: region})
+	}
+	return geo.NewRouter(geo.RouterOptions{DB: db, Nodes: node.Nodes, Paths: cfg.RedirectPaths}), nil
+}
+
+// serverTLS loads the certificates of the HTTP APIs; nil means TLS is off
+func serverTLS(c config.SecurityConfig) (*autotls.TLS, error) {
+	if !c.TLS {
+		return nil, nil
+	}
+	tlsConfig := &autotls.Config{CertFile: c.TLSCertFile, KeyFile: c.TLSKeyFile}
+	if c.ACME.Enabled {
+		tlsConfig.ACME = &autotls.ACMEConfig{
+			Domains:      c.ACME.Domains,
+			Email:        c.ACME.Email,
+			CacheDir:     c.ACME.CacheDir,
+			DirectoryURL: c.ACME.DirectoryURL,
+			RenewBefore:  c.ACME.RenewBefore,
+		}
+	}
+	return autotls.New(tlsConfig)
+}
+
+// httpAPI serves a handler-based API on its own port, over TLS when
+// tlsConfig is set
+func httpAPI(name string, port int, handler http.Handler, readTimeout, writeTimeout time.Duration, tlsConfig *tls.Config) api {
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%d", port),
+		Handler:      handler,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		TLSConfig:    tlsConfig,
+	}
+	return api{
+		name:  name,
+		addr:  server.Addr,
+		serve: func(context.Context) error { return ignoreClosed(listenAndServe(server)) },
+		stop:  server.Shutdown,
+	}
+}
+
+// listenAndServe serves over TLS when the server has a TLS config
+func listenAndServe(server *http.Server) error {
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
+
+// ignoreClosed treats a server closed by a graceful shutdown as a clean exit
+func ignoreClosed(err error) error {
+	if errors.Is(err, http.ErrServerClosed) || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// logsConfig converts the log section of the configuration
+func logsConfig(c config.LogsConfig) streamlog.Config {
+	return streamlog.Config{SegmentBytes: c.SegmentBytes, MaxAge: c.MaxAge, MaxBytes: c.MaxBytes}
+}
+
+// nfsConfig converts the NFS gateway section of the configuration
+func nfsConfig(c config.NFSConfig) *nfs.Config {
+	cfg := &nfs.Config{AttrCacheTTL: c.AttrCacheTTL, WriteDelay: c.WriteDelay, MaxFileSize: c.MaxFileSize}
+	for _, e := range c.Exports {
+		export := nfs.DefaultExport(e.Path, e.Namespace)
+		export.ReadOnly = e.ReadOnly
+		export.Clients = e.Clients
+		if e.Squash != "" {
+			export.Squash = nfs.Squash(e.Squash)
+		}
+		if e.UID != nil {
+			export.UID = *e.UID
+		}
+		if e.GID != nil {
+			export.GID = *e.GID
+		}
+		if e.AnonUID != nil {
+			export.AnonUID = *e.AnonUID
+		}
+		if e.AnonGID != nil {
+			export.AnonGID = *e.AnonGID
+		}
+		if e.FileMode != 0 {
+			export.FileMode = e.FileMode
+		}
+		if e.DirMode != 0 {
+			export.DirMode = e.DirMode
+		}
+		cfg.Exports = append(cfg.Exports, export)
+	}
+	return cfg
+}
+
+// sftpConfig converts the SFTP section of the configuration, loading or
+// creating the host key
+func sftpConfig(c config.SFTPConfig) (*sftp.Config, error) {
+	hostKey, err := sftp.LoadHostKey(c.HostKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &sftp.Config{HostKey: hostKey, MaxFileSize: c.MaxFileSize, SpoolDir: c.SpoolDir}
+	for _, u := range c.Users {
+		user := sftp.User{Name: u.Name, Tenant: u.Tenant, Namespace: u.Namespace, ReadOnly: u.ReadOnly}
+		for _, line := range u.AuthorizedKeys {
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+			if err != nil {
+				return nil, fmt.Errorf("SFTP user %s: invalid authorized key: %w", u.Name, err)
+			}
+			user.AuthorizedKeys = append(user.AuthorizedKeys, key)
+		}
+		cfg.Users = append(cfg.Users, user)
+	}
+	return cfg, nil
+}
+
+// topicsConfig converts the durable topic section of the configuration,
+// nil when durable topics are disabled
+func topicsConfig(c config.TopicsConfig) *pubsub.Config {
+	if !c.Enabled {
+		return nil
+	}
+	return &pubsub.Config{
+		Prefixes:       c.Prefixes,
+		Log:            streamlog.Config{SegmentBytes: c.SegmentBytes, MaxAge: c.MaxAge, MaxBytes: c.MaxBytes},
+		CommitInterval: c.CommitInterval,
+	}
+}
+
+// mqttTLS returns the TLS config of the MQTT broker, which requires device
+// certificates when configured to; nil serves plain MQTT
+func mqttTLS(c config.MQTTConfig, node *fs.Server, server *tls.Config) *tls.Config {
+	if !c.DeviceCertificates || node.Devices == nil || server == nil {
+		return nil
+	}
+	return node.Devices.ClientTLS(server)
+}
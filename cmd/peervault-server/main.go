@@ -0,0 +1,865 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/alerting"
+	"github.com/Skpow1234/Peervault/internal/analytics"
+	"github.com/Skpow1234/Peervault/internal/api/mqtt/topicacl"
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/cache"
+	"github.com/Skpow1234/Peervault/internal/config"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/devices"
+	"github.com/Skpow1234/Peervault/internal/edge"
+	"github.com/Skpow1234/Peervault/internal/export"
+	"github.com/Skpow1234/Peervault/internal/firmware"
+	"github.com/Skpow1234/Peervault/internal/geo"
+	"github.com/Skpow1234/Peervault/internal/hooks"
+	"github.com/Skpow1234/Peervault/internal/logging"
+	"github.com/Skpow1234/Peervault/internal/lwm2m"
+	"github.com/Skpow1234/Peervault/internal/messaging"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+	"github.com/Skpow1234/Peervault/internal/notify"
+	"github.com/Skpow1234/Peervault/internal/peer"
+	"github.com/Skpow1234/Peervault/internal/plugins"
+	"github.com/Skpow1234/Peervault/internal/policy"
+	"github.com/Skpow1234/Peervault/internal/queue"
+	"github.com/Skpow1234/Peervault/internal/retention"
+	"github.com/Skpow1234/Peervault/internal/scan"
+	"github.com/Skpow1234/Peervault/internal/scheduler"
+	"github.com/Skpow1234/Peervault/internal/service"
+	"github.com/Skpow1234/Peervault/internal/storage"
+	"github.com/Skpow1234/Peervault/internal/tasks"
+	"github.com/Skpow1234/Peervault/internal/telemetry"
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/acl"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/chaos"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/join"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/mdns"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/rendezvous"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/seeds"
+	"github.com/Skpow1234/Peervault/pkg/client"
+	"github.com/Skpow1234/Peervault/pkg/plugin"
+)
+
+// serviceName identifies the supervisor to the OS service manager
+const serviceName = "peervault-server"
+
+func main() {
+	configPath := flag.String("config", "config/peervault.yaml", "Path to configuration file")
+	locksPath := flag.String("locks", "", "Path to persist retention locks and legal holds (in memory if empty)")
+	var paths statePaths
+	flag.StringVar(&paths.versions, "versions", "", "Path to persist file version vectors and conflicting versions (in memory if empty)")
+	flag.StringVar(&paths.documents, "documents", "", "Path to persist shared documents (in memory if empty)")
+	flag.StringVar(&paths.analytics, "analytics", "", "Path to persist access analytics rollups (in memory if empty)")
+	flag.StringVar(&paths.accessLog, "access-log", "", "Directory to log every access to, a file per day, for exports of accesses (none if empty)")
+	flag.StringVar(&paths.telemetry, "telemetry", "", "Path to persist device telemetry rollups (in memory if empty)")
+	flag.StringVar(&paths.messages, "messages", "", "Path to persist queued application messages and their receipts (in memory if empty)")
+	flag.StringVar(&paths.reports, "reports", "", "Path to persist scheduled analytics reports (in memory if empty)")
+	flag.StringVar(&paths.alerts, "alerts", "", "Path to persist alert rules, channels, silences and alert states (in memory if empty)")
+	flag.StringVar(&paths.peerACL, "peer-acl", "", "Path to persist peer ACL rules added through the API (in memory if empty)")
+	flag.StringVar(&paths.joinTokens, "join-tokens", "", "Path to persist join tokens created through the API (in memory if empty)")
+	flag.StringVar(&paths.mqttACL, "mqtt-acl", "", "Path to persist MQTT topic ACL rules added through the API (in memory if empty)")
+	flag.StringVar(&paths.pulled, "pulled", "", "Path to persist which files an edge node pulled from its origin (in memory if empty)")
+	flag.StringVar(&paths.schedules, "schedules", "", "Path to persist changed job schedules, paused jobs and their run history (in memory if empty)")
+	flag.StringVar(&paths.queue, "queue", "", "Path to persist queued background jobs and dead letters (in memory if empty)")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "peervault-server:", err)
+		os.Exit(1)
+	}
+	logging.ConfigureLogger(cfg.Logging.Level)
+	logger := slog.Default()
+
+	// Locks are loaded up front: starting without them would let
+	// protected files be deleted
+	locks, err := retention.NewManager(retention.ManagerOpts{Path: *locksPath})
+	if err != nil {
+		logger.Error("Failed to load retention locks", "error", err)
+		os.Exit(1)
+	}
+
+	err = service.Run(serviceName, func(stop <-chan struct{}) error {
+		// Plugins run first: the node and its APIs use what they provide
+		defer stopPlugins(logger)
+		if err := launchPlugins(cfg.Plugins, logger); err != nil {
+			return err
+		}
+		node, injector, err := newFileServer(cfg, locks, paths, logger)
+		if err != nil {
+			return err
+		}
+		if err := node.Start(); err != nil {
+			return fmt.Errorf("failed to start fileserver: %w", err)
+		}
+		defer node.Stop()
+		defer leaveRendezvous(node.Seeds, logger)
+		if node.Policy != nil {
+			defer func() { _ = node.Policy.Close() }()
+		}
+		if node.Hooks != nil {
+			defer func() { _ = node.Hooks.Close() }()
+		}
+		if cfg.Network.MDNS.Enabled {
+			discovery, err := startDiscovery(cfg, node, logger)
+			if err != nil {
+				return err
+			}
+			defer discovery.Stop()
+		}
+		if injector != nil {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if err := runChaos(ctx, injector, cfg.Chaos, logger); err != nil {
+				return err
+			}
+		}
+
+		apis, err := enabledAPIs(cfg, node, locks, logger)
+		if err != nil {
+			return err
+		}
+		if len(apis) == 0 {
+			logger.Warn("No APIs are enabled; running as a storage node only")
+		}
+		return supervise(apis, stop, cfg.Server.ShutdownTimeout, logger)
+	})
+	if err != nil {
+		logger.Error("PeerVault server failed", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("PeerVault server stopped")
+}
+
+// loadConfig reads the config file and environment overrides. Warnings are
+// printed and don't stop the server.
+func loadConfig(path string) (*config.Config, error) {
+	manager := config.NewManager(path)
+	manager.AddValidator(&config.DefaultValidator{})
+	manager.AddValidator(&config.PortValidator{})
+	if err := manager.Load(); err != nil {
+		var result *config.ValidationResult
+		if !errors.As(err, &result) || result.HasErrors() {
+			return nil, err
+		}
+		fmt.Fprintln(os.Stderr, "peervault-server: configuration warnings:", result)
+	}
+	return manager.Get(), nil
+}
+
+// statePaths are the files persisting the node's state besides its
+// storage; empty paths keep that state in memory
+type statePaths struct {
+	versions   string
+	documents  string
+	analytics  string
+	accessLog  string
+	telemetry  string
+	messages   string
+	reports    string
+	alerts     string
+	peerACL    string
+	joinTokens string
+	mqttACL    string
+	pulled     string
+	schedules  string
+	queue      string
+}
+
+// nodeIdentity returns the ID of the node and the identity key proving it.
+// The ID is derived from the identity key kept under the storage root, so
+// it survives restarts; server.node_id overrides it without a key.
+func nodeIdentity(cfg *config.Config, logger *slog.Logger) (string, *crypto.Identity, error) {
+	if cfg.Server.NodeID != "" {
+		logger.Warn("Node ID set in the configuration, peers cannot verify it", "node_id", cfg.Server.NodeID)
+		return cfg.Server.NodeID, nil, nil
+	}
+	path := cfg.Server.IdentityFile
+	if path == "" {
+		path = filepath.Join(cfg.Storage.Root, storage.IdentityFileName)
+	}
+	identity, err := crypto.LoadOrCreateIdentity(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return identity.ID(), identity, nil
+}
+
+// clusterCredentials returns the credentials of the cluster the node is a
+// member of, nil when it does not use join tokens. Saved credentials win;
+// without them the node joins with the configured token or, with join
+// enabled, bootstraps the cluster.
+func clusterCredentials(cfg *config.Config, nodeID string, identity *crypto.Identity, logger *slog.Logger) (*join.Credentials, error) {
+	path := filepath.Join(cfg.Storage.Root, join.CredentialsFileName)
+	creds, err := join.LoadCredentials(path)
+	if err == nil {
+		return creds, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	switch {
+	case cfg.Network.Join.Token != "":
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if creds, err = join.Join(ctx, cfg.Network.Join.Token, nodeID, identity); err != nil {
+			return nil, err
+		}
+		logger.Info("Joined the cluster", "issuer", creds.Issuer, "peers", creds.Peers)
+	case cfg.Network.Join.Enabled:
+		if creds, err = join.NewCredentials(os.Getenv("PEERVAULT_AUTH_TOKEN"), cfg.Security.ClusterKey); err != nil {
+			return nil, err
+		}
+		logger.Info("Created the cluster credentials", "path", path)
+	default:
+		return nil, nil
+	}
+	return creds, join.SaveCredentials(path, creds)
+}
+
+// joinAddr returns the address join tokens point new nodes at
+func joinAddr(cfg *config.Config) string {
+	addr := cfg.Network.Join.AdvertiseAddr
+	if addr == "" {
+		addr = cfg.Server.ListenAddr
+	}
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		if hostname, err := os.Hostname(); err == nil {
+			addr = net.JoinHostPort(hostname, port)
+		}
+	}
+	return addr
+}
+
+// peerACLRules turns the peer ACL of the configuration into rules
+func peerACLRules(c config.PeerACLConfig) []acl.Rule {
+	var rules []acl.Rule
+	for _, id := range c.DenyNodes {
+		rules = append(rules, acl.Rule{Action: acl.Deny, NodeID: id})
+	}
+	for _, cidr := range c.DenyCIDRs {
+		rules = append(rules, acl.Rule{Action: acl.Deny, CIDR: cidr})
+	}
+	if c.RequireIdentity {
+		rules = append(rules, acl.Rule{Action: acl.Deny, Identity: acl.IdentityNone})
+	}
+	for _, id := range c.AllowNodes {
+		rules = append(rules, acl.Rule{Action: acl.Allow, NodeID: id})
+	}
+	for _, cidr := range c.AllowCIDRs {
+		rules = append(rules, acl.Rule{Action: acl.Allow, CIDR: cidr})
+	}
+	return rules
+}
+
+// newFileServer creates the one node every API shares, so they all see the
+// same storage, encryption key and peers. The chaos injector is nil unless
+// chaos is enabled.
+func newFileServer(cfg *config.Config, locks *retention.Manager, paths statePaths, logger *slog.Logger) (*fs.Server, *chaos.Injector, error) {
+	nodeID, identity, err := nodeIdentity(cfg, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	creds, err := clusterCredentials(cfg, nodeID, identity, logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cluster credentials: %w", err)
+	}
+	clusterKey, secret, bootstrapNodes := cfg.Security.ClusterKey, "", cfg.Network.BootstrapNodes
+	if creds != nil {
+		if creds.ClusterKey != "" {
+			clusterKey = creds.ClusterKey
+		}
+		secret = creds.Secret
+		for _, addr := range creds.Peers {
+			if addr != joinAddr(cfg) && !slices.Contains(bootstrapNodes, addr) {
+				bootstrapNodes = append(slices.Clip(bootstrapNodes), addr)
+			}
+		}
+	}
+	if clusterKey == "" {
+		logger.Warn("No cluster key configured, stored files will not be readable after a restart")
+	}
+	keys, err := crypto.NewKeyManagerWithClusterKey(clusterKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid cluster key: %w", err)
+	}
+
+	storageHash := storage.HashSHA1
+	if cfg.Storage.Hash != "" {
+		if storageHash, err = storage.ParseHashAlgorithm(cfg.Storage.Hash); err != nil {
+			return nil, nil, err
+		}
+	}
+	scanner, err := newScanner(cfg.Scan)
+	if err != nil {
+		return nil, nil, err
+	}
+	rules, err := newPolicy(cfg.Policy)
+	if err != nil {
+		return nil, nil, err
+	}
+	hookEngine, err := newHooks(cfg.Hooks)
+	if err != nil {
+		return nil, nil, err
+	}
+	exporter, err := newExports(cfg.Exports)
+	if err != nil {
+		return nil, nil, err
+	}
+	readCache, err := newReadCache(cfg.Performance)
+	if err != nil {
+		return nil, nil, err
+	}
+	pullThrough, err := newPullThrough(cfg.Edge, paths.pulled)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid edge configuration: %w", err)
+	}
+	deviceCA, err := newDeviceCA(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open the device CA: %w", err)
+	}
+	topicACL, err := newTopicACL(cfg.API.MQTT, paths.mqttACL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid MQTT topic ACL: %w", err)
+	}
+	lwm2mServer := newLwM2M(cfg, deviceCA)
+	firmwareManager, err := newFirmware(cfg, deviceCA)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open the firmware distribution: %w", err)
+	}
+	taskHost, err := newTasks(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to register task handlers: %w", err)
+	}
+	var location *geo.Point
+	if cfg.Geo.Location != "" {
+		point, err := geo.ParsePoint(cfg.Geo.Location)
+		if err != nil {
+			return nil, nil, err
+		}
+		location = &point
+	}
+	peerACL, err := acl.New(acl.Options{Rules: peerACLRules(cfg.Network.ACL), Path: paths.peerACL})
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid peer ACL: %w", err)
+	}
+	handshakeOpts := netp2p.HandshakeOptions{Identity: identity, Secret: secret}
+	var joinTokens *join.Registry
+	if cfg.Network.Join.Enabled {
+		if creds == nil {
+			return nil, nil, errors.New("join enabled without cluster credentials")
+		}
+		advertised := joinAddr(cfg)
+		joinTokens, err = join.New(join.Options{
+			NodeID: nodeID,
+			Addrs:  []string{advertised},
+			Credentials: func() join.Credentials {
+				c := *creds
+				c.Peers = append([]string{advertised}, bootstrapNodes...)
+				return c
+			},
+			Check: func(id, addr string, verified bool) error {
+				return peerACL.Check(acl.Peer{Addr: addr, NodeID: id, Verified: verified})
+			},
+			TTL:  cfg.Network.Join.TokenTTL,
+			Path: paths.joinTokens,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		handshakeOpts.Join = joinTokens.Admit
+	}
+	handshake := netp2p.NewHandshakeFunc(nodeID, handshakeOpts)
+	var injector *chaos.Injector
+	if cfg.Chaos.Enabled {
+		injector, err = chaos.New(chaos.Options{
+			Self: []string{nodeID, cfg.Server.ListenAddr},
+			Faults: chaos.Faults{
+				Drop:      cfg.Chaos.DropRate,
+				Delay:     cfg.Chaos.DelayRate,
+				Duplicate: cfg.Chaos.DuplicateRate,
+				Corrupt:   cfg.Chaos.CorruptRate,
+				MinDelay:  cfg.Chaos.MinDelay,
+				MaxDelay:  cfg.Chaos.MaxDelay,
+			},
+			Seed:   cfg.Chaos.Seed,
+			Logger: logger,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	tcpTransport := netp2p.NewTCPTransport(netp2p.TCPTransportOpts{
+		ListenAddr:    cfg.Server.ListenAddr,
+		HandshakeFunc: peerACL.Handshake(handshake),
+		Decoder:       netp2p.LengthPrefixedDecoder{},
+		Limits: netp2p.Limits{
+			ConnectionRate:      cfg.Network.Limits.ConnectionRate,
+			ConnectionBurst:     cfg.Network.Limits.ConnectionBurst,
+			MaxConnectionsPerIP: cfg.Network.Limits.MaxConnectionsPerIP,
+			MaxHandshakes:       cfg.Network.Limits.MaxHandshakes,
+			HandshakeTimeout:    cfg.Network.Limits.HandshakeTimeout,
+			MessageRate:         cfg.Network.Limits.MessageRate,
+			MessageBurst:        cfg.Network.Limits.MessageBurst,
+		},
+	})
+	if injector != nil {
+		tcpTransport.Hook = injector
+	}
+	smtp := notify.SMTPConfig{
+		Addr:     cfg.Notifications.SMTPAddr,
+		From:     cfg.Notifications.SMTPFrom,
+		Username: cfg.Notifications.SMTPUsername,
+		Password: cfg.Notifications.SMTPPassword,
+	}
+	jobs := queue.New(queue.Options{Path: paths.queue})
+	collector := analytics.NewCollector(analytics.Options{Path: paths.analytics, AccessLogDir: paths.accessLog})
+	reports := analytics.NewReports(collector, analytics.ReportsOptions{Path: paths.reports, SMTP: smtp, Queue: jobs})
+	alerts := alerting.NewEngine(alerting.Options{Path: paths.alerts, Node: nodeID, SMTP: smtp, Queue: jobs})
+	node := fs.New(fs.Options{
+		ID:                   nodeID,
+		KeyManager:           keys,
+		StorageRoot:          cfg.Storage.Root,
+		StorageHash:          storageHash,
+		Transport:            tcpTransport,
+		BootstrapNodes:       bootstrapNodes,
+		ResourceLimits:       peer.DefaultResourceLimits(),
+		Retention:            locks,
+		LatencyProbeInterval: cfg.Network.LatencyProbeInterval,
+		ReplicationFactor:    cfg.Storage.ReplicationFactor,
+		Capacity:             cfg.Storage.Capacity,
+		HighWatermark:        cfg.Storage.HighWatermark,
+		VersionsPath:         paths.versions,
+		DocumentsPath:        paths.documents,
+		Analytics:            collector,
+		Telemetry:            telemetry.NewCollector(telemetry.Options{Path: paths.telemetry}),
+		Reports:              reports,
+		Exports:              exporter,
+		Alerts:               alerts,
+		PeerACL:              peerACL,
+		JoinTokens:           joinTokens,
+		KeyRotationInterval:  cfg.Security.KeyRotationInterval,
+		ReencryptionRate:     cfg.Security.ReencryptionRate,
+		Scanner:              scanner,
+		Policy:               rules,
+		Hooks:                hookEngine,
+		Topics:               topicsConfig(cfg.Topics),
+		SwarmMinSize:         cfg.Network.Swarm.MinSize,
+		SwarmPeerRate:        cfg.Network.Swarm.PeerRate,
+		Seeds:                seedSources(cfg, nodeID),
+		SeedInterval:         cfg.Network.SeedInterval,
+		ReadCache:            readCache,
+		Edge:                 pullThrough,
+		Region:               nodeRegion(cfg),
+		Location:             location,
+		PublicURL:            cfg.Geo.PublicURL,
+		Devices:              deviceCA,
+		TopicACL:             topicACL,
+		LwM2M:                lwm2mServer,
+		Firmware:             firmwareManager,
+		Metadata:             metadata.NewStore(metadata.StoreOpts{}),
+		Messaging:            messaging.New(messaging.Options{Path: paths.messages}),
+		Tasks:                taskHost,
+		Scheduler:            scheduler.New(scheduler.Options{Path: paths.schedules}),
+		Queue:                jobs,
+		EventSinks:           pluginEventSinks(),
+	})
+	tcpTransport.OnPeer = node.OnPeer
+
+	logger.Info("Starting PeerVault server",
+		"node_id", nodeID,
+		"listen_addr", cfg.Server.ListenAddr,
+		"storage", cfg.Storage.Root,
+		"bootstrap_nodes", bootstrapNodes,
+	)
+	return node, injector, nil
+}
+
+// newScanner builds the content scanning pipeline, nil when scanning is
+// disabled
+func newScanner(cfg config.ScanConfig) (*scan.Pipeline, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	action, err := scan.ParseAction(cfg.Action)
+	if err != nil {
+		return nil, err
+	}
+	var scanners []scan.Scanner
+	if cfg.Clamd != "" {
+		scanners = append(scanners, scan.NewClamd(cfg.Clamd))
+	}
+	if cfg.ICAP != "" {
+		icap, err := scan.NewICAP(cfg.ICAP)
+		if err != nil {
+			return nil, err
+		}
+		scanners = append(scanners, icap)
+	}
+	for _, p := range plugins.ListExternal() {
+		if scanner := p.Scanner(); scanner != nil {
+			scanners = append(scanners, scan.NewPlugin(p.Name(), scanner))
+		}
+	}
+	return scan.New(scan.Options{
+		AllowTypes:       cfg.AllowTypes,
+		DenyTypes:        cfg.DenyTypes,
+		MaxSize:          cfg.MaxSize,
+		Scanners:         scanners,
+		Action:           action,
+		QuarantinePrefix: cfg.QuarantinePrefix,
+		FailOpen:         cfg.FailOpen,
+		Timeout:          cfg.Timeout,
+	}), nil
+}
+
+// launchPlugins starts the external plugins of the config
+func launchPlugins(cfg config.PluginsConfig, logger *slog.Logger) error {
+	for _, p := range cfg.External {
+		_, err := plugins.LaunchExternal(plugins.ExternalConfig{
+			Name:    p.Name,
+			Path:    p.Path,
+			Args:    p.Args,
+			Env:     p.Env,
+			Timeout: p.Timeout,
+		}, logger)
+		if err != nil {
+			return fmt.Errorf("failed to launch plugin %s: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// stopPlugins stops the external plugins after the node and its APIs
+// stopped
+func stopPlugins(logger *slog.Logger) {
+	if err := plugins.StopExternal(); err != nil {
+		logger.Warn("Failed to stop plugins", "error", err)
+	}
+}
+
+// pluginEventSinks returns the event sinks of the external plugins by
+// plugin name
+func pluginEventSinks() map[string]plugin.EventSink {
+	sinks := make(map[string]plugin.EventSink)
+	for _, p := range plugins.ListExternal() {
+		if sink := p.EventSink(); sink != nil {
+			sinks[p.Name()] = sink
+		}
+	}
+	return sinks
+}
+
+// newReadCache builds the cache of hot files, nil when its size is zero
+func newReadCache(cfg config.PerformanceConfig) (*cache.ByteCache[[]byte], error) {
+	if cfg.CacheSize <= 0 {
+		return nil, nil
+	}
+	return cache.NewByteCache[[]byte](int64(cfg.CacheSize)<<20, cfg.CachePolicy, cfg.CacheTTL)
+}
+
+// newPullThrough makes the node a pull-through cache of the upstream
+// cluster, nil when none is configured
+func newPullThrough(cfg config.EdgeConfig, path string) (*edge.PullThrough, error) {
+	if cfg.Upstream == "" {
+		return nil, nil
+	}
+	var opts []client.Option
+	if cfg.Token != "" {
+		opts = append(opts, client.WithToken(cfg.Token))
+	}
+	upstream, err := client.New(cfg.Upstream, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return edge.NewPullThrough(edge.PullOptions{Origin: clientOrigin{upstream}, TTL: cfg.TTL, Prefixes: cfg.Prefixes, Path: path})
+}
+
+// newDeviceCA opens the CA issuing IoT device certificates, nil when it
+// is not enabled
+func newDeviceCA(cfg *config.Config) (*devices.CA, error) {
+	if !cfg.Devices.Enabled {
+		return nil, nil
+	}
+	dir := cfg.Devices.Dir
+	if dir == "" {
+		dir = filepath.Join(cfg.Storage.Root, "devices")
+	}
+	return devices.New(devices.Options{Dir: dir, Validity: cfg.Devices.Validity})
+}
+
+// newTopicACL returns the topic ACL of the MQTT broker, with the rules of
+// its ACL file, nil when the broker is not enabled
+func newTopicACL(cfg config.MQTTConfig, path string) (*topicacl.ACL, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	var rules []topicacl.Rule
+	if cfg.ACLFile != "" {
+		var err error
+		if rules, err = topicacl.LoadRules(cfg.ACLFile); err != nil {
+			return nil, err
+		}
+	}
+	return topicacl.New(topicacl.Options{Rules: rules, Path: path})
+}
+
+// newLwM2M returns the LwM2M server of the CoAP API, nil when it is not
+// enabled. With the device CA, only the devices it registered may
+// register, by their device ID.
+func newLwM2M(cfg *config.Config, deviceCA *devices.CA) *lwm2m.Server {
+	if !cfg.API.CoAP.Enabled || !cfg.API.CoAP.LwM2M {
+		return nil
+	}
+	return lwm2m.New(lwm2m.Options{Devices: deviceCA})
+}
+
+// newFirmware returns the firmware distribution, nil when it is not
+// enabled. With the device CA, only the devices it registered get updates.
+func newFirmware(cfg *config.Config, deviceCA *devices.CA) (*firmware.Manager, error) {
+	if !cfg.Firmware.Enabled {
+		return nil, nil
+	}
+	dir := cfg.Firmware.Dir
+	if dir == "" {
+		dir = filepath.Join(cfg.Storage.Root, "firmware")
+	}
+	return firmware.New(firmware.Options{Dir: dir, Devices: deviceCA})
+}
+
+// newTasks returns the task host with the built-in handlers and the
+// processing plugins registered, nil when tasks are not enabled
+func newTasks(cfg *config.Config) (*tasks.Host, error) {
+	if !cfg.Tasks.Enabled {
+		return nil, nil
+	}
+	host := tasks.New(tasks.Options{
+		Allow:         cfg.Tasks.Allow,
+		MaxConcurrent: cfg.Tasks.MaxConcurrent,
+		Timeout:       cfg.Tasks.Timeout,
+	})
+	if err := tasks.RegisterBuiltins(host); err != nil {
+		return nil, err
+	}
+	if err := tasks.RegisterPlugins(host); err != nil {
+		return nil, err
+	}
+	return host, nil
+}
+
+// nodeRegion returns the region the node tells its peers it is in
+func nodeRegion(cfg *config.Config) string {
+	if cfg.Geo.Region != "" {
+		return cfg.Geo.Region
+	}
+	return cfg.Policy.Region
+}
+
+// clientOrigin pulls files through the REST API of the upstream cluster
+type clientOrigin struct {
+	client *client.Client
+}
+
+func (o clientOrigin) Fetch(ctx context.Context, key, etag string) ([]byte, string, error) {
+	body, current, err := o.client.GetIfNoneMatch(ctx, key, etag)
+	switch {
+	case errors.Is(err, client.ErrNotModified):
+		return nil, current, edge.ErrNotModified
+	case errors.Is(err, client.ErrNotFound):
+		return nil, "", edge.ErrOriginNotFound
+	case err != nil:
+		return nil, "", err
+	}
+	defer func() { _ = body.Close() }()
+	data, err := io.ReadAll(body)
+	return data, current, err
+}
+
+// newPolicy loads the policy rules, nil when no rules file is configured
+func newPolicy(cfg config.PolicyConfig) (*policy.Engine, error) {
+	if cfg.File == "" {
+		return nil, nil
+	}
+	rules, err := policy.LoadPolicy(cfg.File)
+	if err != nil {
+		return nil, err
+	}
+	return policy.New(policy.Options{
+		Policy:  rules,
+		DryRun:  cfg.DryRun,
+		LogPath: cfg.DecisionLog,
+		Region:  cfg.Region,
+		Regions: cfg.Regions,
+	})
+}
+
+// newHooks compiles the hooks, nil when no hooks file is configured
+func newHooks(cfg config.HooksConfig) (*hooks.Engine, error) {
+	if cfg.File == "" {
+		return nil, nil
+	}
+	hooksConfig, err := hooks.LoadConfig(cfg.File)
+	if err != nil {
+		return nil, err
+	}
+	return hooks.New(hooksConfig)
+}
+
+// newExports loads the exports, nil when no exports file is configured
+func newExports(cfg config.ExportsConfig) (*export.Exporter, error) {
+	if cfg.File == "" {
+		return nil, nil
+	}
+	exportsConfig, err := export.LoadConfig(cfg.File)
+	if err != nil {
+		return nil, err
+	}
+	return export.New(exportsConfig)
+}
+
+// seedSources returns the DNS seeds and rendezvous service of the
+// configuration
+func seedSources(cfg *config.Config, nodeID string) []seeds.Source {
+	var sources []seeds.Source
+	if len(cfg.Network.Seeds) > 0 {
+		sources = append(sources, &seeds.DNS{Names: cfg.Network.Seeds})
+	}
+	if r := cfg.Network.Rendezvous; r.URL != "" {
+		addr := r.AdvertiseAddr
+		if addr == "" {
+			addr = cfg.Server.ListenAddr
+		}
+		sources = append(sources, &rendezvous.Client{
+			URL:     r.URL,
+			Token:   r.Token,
+			NodeID:  nodeID,
+			Addr:    addr,
+			Cluster: r.Cluster,
+			HTTP:    &http.Client{Timeout: 10 * time.Second},
+		})
+	}
+	return sources
+}
+
+// leaveRendezvous unregisters the node from its rendezvous service, so
+// peers stop dialing it before the registration expires
+func leaveRendezvous(sources []seeds.Source, logger *slog.Logger) {
+	for _, source := range sources {
+		if client, ok := source.(*rendezvous.Client); ok {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := client.Unregister(ctx); err != nil {
+				logger.Warn("Failed to unregister from the rendezvous service", "error", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// startDiscovery announces the node on the local network and connects to
+// the nodes of its cluster found there
+func startDiscovery(cfg *config.Config, node *fs.Server, logger *slog.Logger) (*mdns.Discovery, error) {
+	_, portStr, err := net.SplitHostPort(cfg.Server.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("mDNS needs the port of %q: %w", cfg.Server.ListenAddr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("mDNS needs the port of %q: %w", cfg.Server.ListenAddr, err)
+	}
+	discovery, err := mdns.New(mdns.Options{
+		NodeID:   node.ID,
+		Port:     port,
+		Cluster:  cfg.Network.MDNS.Cluster,
+		Service:  cfg.Network.MDNS.Service,
+		Interval: cfg.Network.MDNS.Interval,
+		OnPeer:   func(p mdns.Peer) { node.ConnectDiscovered(p.ID, p.Addr) },
+		Logger:   logger,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := discovery.Start(); err != nil {
+		return nil, err
+	}
+	return discovery, nil
+}
+
+// runChaos starts the configured connection kills and experiment, which
+// stop with ctx
+func runChaos(ctx context.Context, injector *chaos.Injector, cfg config.ChaosConfig, logger *slog.Logger) error {
+	var experiment *chaos.Experiment
+	if cfg.Experiment != "" {
+		var err error
+		if experiment, err = chaos.LoadExperiment(cfg.Experiment); err != nil {
+			return err
+		}
+	}
+	if cfg.KillInterval > 0 {
+		go func() { _ = injector.KillEvery(ctx, cfg.KillInterval) }()
+	}
+	if experiment != nil {
+		go func() {
+			if err := injector.Run(ctx, experiment); err != nil && ctx.Err() == nil {
+				logger.Error("Chaos experiment failed", "error", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// supervise runs every API until stop is closed or one of them fails, then
+// shuts them all down in reverse order
+func supervise(apis []api, stop <-chan struct{}, timeout time.Duration, logger *slog.Logger) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	failed := make(chan error, len(apis))
+	for _, a := range apis {
+		go func() {
+			logger.Info("Starting API", "api", a.name, "addr", a.addr)
+			if err := a.serve(ctx); err != nil && ctx.Err() == nil {
+				failed <- fmt.Errorf("%s API: %w", a.name, err)
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case <-stop:
+		logger.Info("Shutting down PeerVault server")
+	case err = <-failed:
+		logger.Error("API failed, shutting down", "error", err)
+	}
+	cancel()
+
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), timeout)
+	defer cancelShutdown()
+	for i := len(apis) - 1; i >= 0; i-- {
+		if apis[i].stop == nil {
+			continue
+		}
+		if stopErr := apis[i].stop(shutdownCtx); stopErr != nil {
+			logger.Error("Error stopping API", "api", apis[i].name, "error", stopErr)
+		}
+	}
+	return err
+}
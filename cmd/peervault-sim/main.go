@@ -0,0 +1,132 @@
+// Command peervault-sim plays scripted scenarios against a simulated Raft
+// group. Time, message delivery and randomness all come from the seed, so a
+// failing run replays exactly: the command prints the seed of each failure,
+// and -seed reruns it. With -runs it tries consecutive seeds, looking for
+// one that breaks an expectation or an invariant.
+//
+//	peervault-sim -runs 100 config/sim/*.yaml
+//	peervault-sim -seed 1234 -trace config/sim/member-isolated.yaml
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/Skpow1234/Peervault/pkg/testkit"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout))
+}
+
+func run(args []string, stdout io.Writer) int {
+	var (
+		seed    uint64
+		runs    int
+		trace   bool
+		asJSON  bool
+		verbose bool
+	)
+	flags := flag.NewFlagSet("peervault-sim", flag.ContinueOnError)
+	flags.Uint64Var(&seed, "seed", 0, "Seed to run instead of the scenario's own")
+	flags.IntVar(&runs, "runs", 1, "Number of consecutive seeds to run each scenario with")
+	flags.BoolVar(&trace, "trace", false, "Print the trace of failed runs, or of every run with -verbose")
+	flags.BoolVar(&asJSON, "json", false, "Print one JSON report per run")
+	flags.BoolVar(&verbose, "verbose", false, "Log the Raft groups to stderr")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: peervault-sim [flags] scenario.yaml...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() == 0 || runs < 1 {
+		flags.Usage()
+		return 2
+	}
+	scenarios := make([]*testkit.Scenario, 0, flags.NArg())
+	for _, path := range flags.Args() {
+		sc, err := testkit.LoadScenario(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			return 2
+		}
+		if sc.Name == "" {
+			sc.Name = path
+		}
+		scenarios = append(scenarios, sc)
+	}
+
+	level := slog.LevelWarn
+	if verbose {
+		level = slog.LevelInfo
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	encoder := json.NewEncoder(stdout)
+	failed := 0
+	for i, sc := range scenarios {
+		if seed != 0 {
+			sc.Seed = seed
+		}
+		for n := range runs {
+			if n > 0 {
+				sc.Seed++
+			}
+			report, err := testkit.RunScenario(sc)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", flags.Arg(i), err)
+				return 1
+			}
+			if !report.Passed {
+				failed++
+			}
+			if asJSON {
+				if !trace || (report.Passed && !verbose) {
+					report.Trace = nil
+				}
+				if err := encoder.Encode(report); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+					return 1
+				}
+				continue
+			}
+			printReport(stdout, report, trace && (!report.Passed || verbose))
+			if !report.Passed {
+				fmt.Fprintf(stdout, "  replay: peervault-sim -seed %d -trace %s\n", report.Seed, flags.Arg(i))
+			}
+		}
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printReport(w io.Writer, r *testkit.SimReport, trace bool) {
+	status := "PASS"
+	if !r.Passed {
+		status = "FAIL"
+	}
+	fmt.Fprintf(w, "%s %s seed=%d elapsed=%s fingerprint=%s", status, r.Scenario, r.Seed, r.Elapsed, r.Fingerprint)
+	if r.Leader != "" {
+		fmt.Fprintf(w, " leader=%s", r.Leader)
+	}
+	fmt.Fprintln(w)
+	if r.Error != "" {
+		fmt.Fprintf(w, "  %s\n", r.Error)
+	}
+	if trace {
+		for _, line := range r.Trace {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+}
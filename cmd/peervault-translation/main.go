@@ -13,6 +13,7 @@ import (
 	"github.com/Skpow1234/Peervault/internal/api/translation"
 	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
 	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/diagnostics"
 	"github.com/Skpow1234/Peervault/internal/peer"
 	"github.com/Skpow1234/Peervault/internal/storage"
 	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
@@ -32,6 +33,7 @@ func main() {
 		mqttAddr      = flag.String("mqtt", "localhost:1883", "MQTT server address")
 		coapAddr      = flag.String("coap", "localhost:5683", "CoAP server address")
 	)
+	diag := diagnostics.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
 	// Setup logging
@@ -44,6 +46,13 @@ func main() {
 		Level: logLevel,
 	}))
 
+	// Serve profiles and runtime stats when -diagnostics-addr is set
+	diag.Logger = logger
+	if _, err := diagnostics.Start(*diag); err != nil {
+		logger.Error("Failed to start diagnostics", "error", err)
+		os.Exit(1)
+	}
+
 	// Create file server instance (simplified for translation API)
 	fileServer := createFileServer(*listenAddr, logger)
 
@@ -8,12 +8,16 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/Skpow1234/Peervault/internal/api/httpguard"
 	"github.com/Skpow1234/Peervault/internal/api/websocket"
 	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/autotls"
 	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/diagnostics"
 	"github.com/Skpow1234/Peervault/internal/peer"
 	"github.com/Skpow1234/Peervault/internal/storage"
 	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
@@ -26,7 +30,10 @@ func main() {
 		host       = flag.String("host", "localhost", "WebSocket server host")
 		listenAddr = flag.String("listen", ":3000", "P2P listen address")
 		verbose    = flag.Bool("verbose", false, "Enable verbose logging")
+		origins    = flag.String("origins", "*", "Comma-separated origins allowed to connect from browsers (* allows any)")
 	)
+	diag := diagnostics.RegisterFlags(flag.CommandLine)
+	tlsFlags := autotls.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
 	// Setup logging
@@ -39,6 +46,20 @@ func main() {
 		Level: logLevel,
 	}))
 
+	// Serve profiles and runtime stats when -diagnostics-addr is set
+	diag.Logger = logger
+	if _, err := diagnostics.Start(*diag); err != nil {
+		logger.Error("Failed to start diagnostics", "error", err)
+		os.Exit(1)
+	}
+
+	// Serve HTTPS when -tls-cert or -acme-domains is set
+	certs, err := tlsFlags.Load()
+	if err != nil {
+		logger.Error("Failed to load TLS certificates", "error", err)
+		os.Exit(1)
+	}
+
 	// Create file server instance (simplified for WebSocket API)
 	fileServer := createFileServer(*listenAddr, logger)
 
@@ -46,12 +67,13 @@ func main() {
 	wsConfig := &websocket.Config{
 		Port:           *port,
 		Host:           *host,
-		AllowedOrigins: []string{"*"}, // TODO: Make configurable
+		AllowedOrigins: strings.Split(*origins, ","),
 		EnableCORS:     true,
 		ReadTimeout:    30 * time.Second,
 		WriteTimeout:   30 * time.Second,
 		PingPeriod:     54 * time.Second,
 		PongWait:       60 * time.Second,
+		Security:       httpguard.DefaultPolicy(),
 	}
 
 	wsServer := websocket.NewServer(fileServer, wsConfig, logger)
@@ -62,6 +84,7 @@ func main() {
 		Handler:      wsServer,
 		ReadTimeout:  wsConfig.ReadTimeout,
 		WriteTimeout: wsConfig.WriteTimeout,
+		TLSConfig:    certs.Config(),
 	}
 
 	// Start server in a goroutine
@@ -77,7 +100,11 @@ func main() {
 			},
 		)
 
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		serve := server.ListenAndServe
+		if server.TLSConfig != nil {
+			serve = func() error { return server.ListenAndServeTLS("", "") }
+		}
+		if err := serve(); err != nil && err != http.ErrServerClosed {
 			logger.Error("WebSocket server failed", "error", err)
 			os.Exit(1)
 		}
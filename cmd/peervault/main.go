@@ -13,6 +13,7 @@ import (
 	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
 	"github.com/Skpow1234/Peervault/internal/crypto"
 	"github.com/Skpow1234/Peervault/internal/logging"
+	"github.com/Skpow1234/Peervault/internal/metadata"
 	"github.com/Skpow1234/Peervault/internal/peer"
 	"github.com/Skpow1234/Peervault/internal/storage"
 	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
@@ -36,6 +37,7 @@ func makeServer(listenAddr string, nodes ...string) *fs.Server {
 		Transport:         tcpTransport,
 		BootstrapNodes:    nodes,
 		ResourceLimits:    peer.DefaultResourceLimits(),
+		Metadata:          metadata.NewStore(metadata.StoreOpts{}),
 	}
 	s := fs.New(fileServerOpts)
 	tcpTransport.OnPeer = s.OnPeer
@@ -0,0 +1,496 @@
+package alerting
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/notify"
+	"github.com/Skpow1234/Peervault/internal/queue"
+)
+
+const (
+	// DefaultRepeatInterval is how often a firing alert is notified again
+	DefaultRepeatInterval = 4 * time.Hour
+	// maxHistory bounds the samples kept per metric for rate rules
+	maxHistory = 4096
+	// notifyTimeout bounds a webhook call
+	notifyTimeout = 10 * time.Second
+)
+
+// Options configures an engine
+type Options struct {
+	// Path persists rules, channels, silences and alert states; empty
+	// keeps them in memory
+	Path string
+	// Node names the node in notifications
+	Node string
+	// SMTP is the mail server of email channels
+	SMTP notify.SMTPConfig
+	// Client posts to webhooks; nil uses a client with a timeout
+	Client *http.Client
+	// Queue, when set, delivers webhooks in jobs retried until they
+	// succeed rather than posting them once
+	Queue *queue.Queue
+	// RepeatInterval is how often firing alerts are notified again; zero
+	// uses DefaultRepeatInterval
+	RepeatInterval time.Duration
+}
+
+type point struct {
+	t time.Time
+	v float64
+}
+
+// Engine evaluates alert rules over the samples it observes
+type Engine struct {
+	opts    Options
+	started time.Time
+	now     func() time.Time
+
+	mu       sync.Mutex
+	rules    map[string]*Rule
+	channels map[string]*Channel
+	silences map[string]*Silence
+	alerts   map[string]*Alert
+	series   map[string][]point
+}
+
+// NewEngine creates an engine without rules
+func NewEngine(opts Options) *Engine {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: notifyTimeout}
+	}
+	if opts.RepeatInterval <= 0 {
+		opts.RepeatInterval = DefaultRepeatInterval
+	}
+	return &Engine{
+		opts:     opts,
+		started:  time.Now(),
+		now:      time.Now,
+		rules:    make(map[string]*Rule),
+		channels: make(map[string]*Channel),
+		silences: make(map[string]*Silence),
+		alerts:   make(map[string]*Alert),
+		series:   make(map[string][]point),
+	}
+}
+
+// Rules returns the rules by name
+func (e *Engine) Rules() []Rule {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	rules := make([]Rule, 0, len(e.rules))
+	for _, name := range sortedKeys(e.rules) {
+		rules = append(rules, *e.rules[name])
+	}
+	return rules
+}
+
+// PutRule creates or replaces a rule, filling in its defaults. A replaced
+// rule keeps its alert state.
+func (e *Engine) PutRule(r Rule) (Rule, error) {
+	if err := r.normalize(); err != nil {
+		return Rule{}, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, ch := range r.Channels {
+		if _, ok := e.channels[ch]; !ok {
+			return Rule{}, invalid("unknown channel %q", ch)
+		}
+	}
+	e.rules[r.Name] = &r
+	if a, ok := e.alerts[r.Name]; ok {
+		a.Severity, a.Summary = r.Severity, r.Summary
+	} else {
+		e.alerts[r.Name] = &Alert{Rule: r.Name, Severity: r.Severity, Summary: r.Summary, State: StateInactive}
+	}
+	return r, e.save()
+}
+
+// DeleteRule removes a rule and its alert without notifying anyone
+func (e *Engine) DeleteRule(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.rules[name]; !ok {
+		return fmt.Errorf("%w: rule %s", ErrNotFound, name)
+	}
+	delete(e.rules, name)
+	delete(e.alerts, name)
+	return e.save()
+}
+
+// Channels returns the channels by name
+func (e *Engine) Channels() []Channel {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	channels := make([]Channel, 0, len(e.channels))
+	for _, name := range sortedKeys(e.channels) {
+		channels = append(channels, *e.channels[name])
+	}
+	return channels
+}
+
+// PutChannel creates or replaces a channel
+func (e *Engine) PutChannel(c Channel) (Channel, error) {
+	if err := c.normalize(); err != nil {
+		return Channel{}, err
+	}
+	if c.Type == ChannelEmail && !e.opts.SMTP.Enabled() {
+		return Channel{}, invalid("email channels need an SMTP server configured on the node")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.channels[c.Name] = &c
+	return c, e.save()
+}
+
+// DeleteChannel removes a channel no rule uses
+func (e *Engine) DeleteChannel(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.channels[name]; !ok {
+		return fmt.Errorf("%w: channel %s", ErrNotFound, name)
+	}
+	for _, ruleName := range sortedKeys(e.rules) {
+		for _, ch := range e.rules[ruleName].Channels {
+			if ch == name {
+				return invalid("channel %s is used by rule %s", name, ruleName)
+			}
+		}
+	}
+	delete(e.channels, name)
+	return e.save()
+}
+
+// Silences returns the silences that have not ended, by end time
+func (e *Engine) Silences() []Silence {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pruneSilences(e.now())
+	silences := make([]Silence, 0, len(e.silences))
+	for _, id := range sortedKeys(e.silences) {
+		silences = append(silences, *e.silences[id])
+	}
+	return silences
+}
+
+// AddSilence mutes notifications until s.EndsAt, starting now unless
+// s.StartsAt is set. The silence gets a new ID.
+func (e *Engine) AddSilence(s Silence) (Silence, error) {
+	now := e.now()
+	if s.StartsAt.IsZero() {
+		s.StartsAt = now
+	}
+	if !s.EndsAt.After(s.StartsAt) || !s.EndsAt.After(now) {
+		return Silence{}, invalid("a silence must end in the future and after it starts")
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Silence{}, err
+	}
+	s.ID = hex.EncodeToString(id)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if s.Rule != "" {
+		if _, ok := e.rules[s.Rule]; !ok {
+			return Silence{}, fmt.Errorf("%w: rule %s", ErrNotFound, s.Rule)
+		}
+	}
+	e.silences[s.ID] = &s
+	e.markSilenced(now)
+	return s, e.save()
+}
+
+// DeleteSilence ends a silence early
+func (e *Engine) DeleteSilence(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.silences[id]; !ok {
+		return fmt.Errorf("%w: silence %s", ErrNotFound, id)
+	}
+	delete(e.silences, id)
+	e.markSilenced(e.now())
+	return e.save()
+}
+
+// Alerts returns the state of every rule by rule name
+func (e *Engine) Alerts() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	alerts := make([]Alert, 0, len(e.alerts))
+	for _, name := range sortedKeys(e.alerts) {
+		alerts = append(alerts, *e.alerts[name])
+	}
+	return alerts
+}
+
+// Samples returns the latest value of every metric observed, by name
+func (e *Engine) Samples() []Sample {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	samples := make([]Sample, 0, len(e.series))
+	for _, name := range sortedKeys(e.series) {
+		last := e.series[name][len(e.series[name])-1]
+		samples = append(samples, Sample{Metric: name, Value: last.v, Time: last.t})
+	}
+	return samples
+}
+
+// Observe records metric values taken at t. Rate rules need at least two
+// observations within their window.
+func (e *Engine) Observe(values map[string]float64, t time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	keep := time.Duration(0)
+	for _, r := range e.rules {
+		keep = max(keep, r.window)
+	}
+	for name, v := range values {
+		points := append(e.series[name], point{t, v})
+		// Keep one point older than the longest window so rates span it
+		drop := 0
+		for drop+1 < len(points) && !points[drop+1].t.After(t.Add(-keep)) {
+			drop++
+		}
+		drop = max(drop, len(points)-maxHistory)
+		if drop > 0 {
+			// Copy so the array of the dropped points can be collected
+			points = append([]point(nil), points[drop:]...)
+		}
+		e.series[name] = points
+	}
+}
+
+// notification is an alert about to be sent
+type notification struct {
+	Alert
+	Status    string
+	Rule      Rule
+	Channels  []Channel
+	Threshold float64
+}
+
+// Evaluate checks every rule against the samples observed up to now and
+// notifies the channels of alerts that fired, resolved or are due a
+// repeat notification
+func (e *Engine) Evaluate(ctx context.Context, now time.Time) {
+	e.mu.Lock()
+	e.pruneSilences(now)
+	var outbox []notification
+	for _, name := range sortedKeys(e.rules) {
+		r := e.rules[name]
+		a := e.alerts[name]
+		value, ok, met := e.evaluate(r, now)
+		a.Value, a.HasValue = value, ok
+		a.Silenced = e.silenced(name, now)
+
+		status := ""
+		switch {
+		case met && a.State == StateInactive:
+			a.State, a.Since = StatePending, now
+			if r.hold > 0 {
+				break
+			}
+			fallthrough
+		case met && a.State == StatePending && !now.Before(a.Since.Add(r.hold)):
+			a.State, a.Since, a.FiredAt = StateFiring, now, now
+			status = "firing"
+		case met && a.State == StateFiring && !now.Before(a.LastNotified.Add(e.opts.RepeatInterval)):
+			status = "firing"
+		case !met && a.State == StateFiring:
+			a.State, a.Since, a.ResolvedAt = StateInactive, now, now
+			status = "resolved"
+		case !met && a.State == StatePending:
+			a.State, a.Since = StateInactive, now
+		}
+		if status == "" || a.Silenced || len(r.Channels) == 0 {
+			continue
+		}
+		n := notification{Alert: *a, Status: status, Rule: *r}
+		for _, ch := range r.Channels {
+			if c, ok := e.channels[ch]; ok {
+				n.Channels = append(n.Channels, *c)
+			}
+		}
+		a.LastNotified = now
+		outbox = append(outbox, n)
+	}
+	if err := e.save(); err != nil {
+		slog.Warn("failed to persist alerts", "error", err)
+	}
+	e.mu.Unlock()
+
+	for _, n := range outbox {
+		err := e.send(ctx, n)
+		e.mu.Lock()
+		if a, ok := e.alerts[n.Rule.Name]; ok {
+			a.LastError = ""
+			if err != nil {
+				a.LastError = err.Error()
+			}
+		}
+		e.mu.Unlock()
+		if err != nil {
+			slog.Warn("failed to send alert notification", "rule", n.Rule.Name, "status", n.Status, "error", err)
+		}
+	}
+}
+
+// evaluate returns the value of r at now, whether there was data to
+// compute it and whether the condition is met; callers hold mu
+func (e *Engine) evaluate(r *Rule, now time.Time) (float64, bool, bool) {
+	points := e.series[r.Metric]
+	switch r.Kind {
+	case KindAbsence:
+		seen := e.started
+		if len(points) > 0 {
+			seen = points[len(points)-1].t
+		}
+		age := now.Sub(seen)
+		return age.Seconds(), true, age > r.window
+	case KindRate:
+		var base *point
+		for i := range points {
+			if !points[i].t.Before(now.Add(-r.window)) {
+				break
+			}
+			base = &points[i]
+		}
+		if len(points) < 2 {
+			return 0, false, false
+		}
+		if base == nil {
+			base = &points[0]
+		}
+		last := points[len(points)-1]
+		secs := last.t.Sub(base.t).Seconds()
+		if secs <= 0 {
+			return 0, false, false
+		}
+		rate := (last.v - base.v) / secs
+		return rate, true, operators[r.Op](rate, r.Threshold)
+	}
+	if len(points) == 0 {
+		return 0, false, false
+	}
+	v := points[len(points)-1].v
+	return v, true, operators[r.Op](v, r.Threshold)
+}
+
+// silenced reports whether a silence mutes rule at t; callers hold mu
+func (e *Engine) silenced(rule string, t time.Time) bool {
+	for _, s := range e.silences {
+		if s.matches(rule, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// markSilenced refreshes the Silenced flag of every alert; callers hold mu
+func (e *Engine) markSilenced(t time.Time) {
+	for name, a := range e.alerts {
+		a.Silenced = e.silenced(name, t)
+	}
+}
+
+// pruneSilences drops ended silences; callers hold mu
+func (e *Engine) pruneSilences(t time.Time) {
+	for id, s := range e.silences {
+		if !t.Before(s.EndsAt) {
+			delete(e.silences, id)
+		}
+	}
+}
+
+// state is what the engine persists
+type state struct {
+	Rules    []*Rule    `json:"rules"`
+	Channels []*Channel `json:"channels"`
+	Silences []*Silence `json:"silences"`
+	Alerts   []*Alert   `json:"alerts"`
+}
+
+// save persists the engine; callers hold mu
+func (e *Engine) save() error {
+	if e.opts.Path == "" {
+		return nil
+	}
+	var st state
+	for _, name := range sortedKeys(e.rules) {
+		st.Rules = append(st.Rules, e.rules[name])
+	}
+	for _, name := range sortedKeys(e.channels) {
+		st.Channels = append(st.Channels, e.channels[name])
+	}
+	for _, id := range sortedKeys(e.silences) {
+		st.Silences = append(st.Silences, e.silences[id])
+	}
+	for _, name := range sortedKeys(e.alerts) {
+		st.Alerts = append(st.Alerts, e.alerts[name])
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(e.opts.Path), 0700); err != nil {
+		return err
+	}
+	tmp := e.opts.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, e.opts.Path)
+}
+
+// Load reads the persisted rules, channels, silences and alert states
+func (e *Engine) Load() error {
+	if e.opts.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(e.opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("alerting: corrupt state %s: %w", e.opts.Path, err)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, c := range st.Channels {
+		e.channels[c.Name] = c
+	}
+	for _, r := range st.Rules {
+		if err := r.normalize(); err != nil {
+			return fmt.Errorf("alerting: rule %s: %w", r.Name, err)
+		}
+		e.rules[r.Name] = r
+		e.alerts[r.Name] = &Alert{Rule: r.Name, Severity: r.Severity, Summary: r.Summary, State: StateInactive}
+	}
+	for _, a := range st.Alerts {
+		if _, ok := e.rules[a.Rule]; ok {
+			e.alerts[a.Rule] = a
+		}
+	}
+	for _, s := range st.Silences {
+		e.silences[s.ID] = s
+	}
+	e.pruneSilences(e.now())
+	return nil
+}
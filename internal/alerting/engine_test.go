@@ -0,0 +1,230 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hook records the JSON bodies posted to it
+type hook struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies []map[string]any
+}
+
+func newHook(t *testing.T) *hook {
+	h := &hook{}
+	h.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.mu.Lock()
+		h.bodies = append(h.bodies, body)
+		h.mu.Unlock()
+	}))
+	t.Cleanup(h.Close)
+	return h
+}
+
+func (h *hook) received() []map[string]any {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]map[string]any(nil), h.bodies...)
+}
+
+func TestRuleValidation(t *testing.T) {
+	e := NewEngine(Options{})
+	rule, err := e.PutRule(Rule{Name: "no-peers", Metric: "peers", Op: "<", Threshold: 1})
+	require.NoError(t, err)
+	assert.Equal(t, KindThreshold, rule.Kind)
+	assert.Equal(t, SeverityWarning, rule.Severity)
+
+	for _, r := range []Rule{
+		{Name: "../up", Metric: "peers", Op: ">"},
+		{Name: "r", Op: ">"},
+		{Name: "r", Metric: "peers", Op: "~"},
+		{Name: "r", Metric: "peers", Kind: "rate", Op: ">"},
+		{Name: "r", Metric: "peers", Kind: "absence"},
+		{Name: "r", Metric: "peers", Kind: "spike", Op: ">"},
+		{Name: "r", Metric: "peers", Op: ">", For: "soon"},
+		{Name: "r", Metric: "peers", Op: ">", Severity: "page"},
+		{Name: "r", Metric: "peers", Op: ">", Channels: []string{"ops"}},
+	} {
+		_, err := e.PutRule(r)
+		assert.ErrorIs(t, err, ErrInvalid, "%+v", r)
+	}
+
+	for _, c := range []Channel{
+		{Name: "c", Type: ChannelWebhook, URL: "ftp://example.com"},
+		{Name: "c", Type: ChannelEmail},
+		{Name: "c", Type: ChannelEmail, To: []string{"ops@example.com"}},
+		{Name: "c", Type: "pager"},
+	} {
+		_, err := e.PutChannel(c)
+		assert.ErrorIs(t, err, ErrInvalid, "%+v", c)
+	}
+
+	assert.ErrorIs(t, e.DeleteRule("missing"), ErrNotFound)
+	_, err = e.AddSilence(Silence{EndsAt: time.Now().Add(-time.Minute)})
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, err = e.AddSilence(Silence{Rule: "missing", EndsAt: time.Now().Add(time.Hour)})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestThresholdAlertFiresRepeatsAndResolves(t *testing.T) {
+	webhook, slack := newHook(t), newHook(t)
+	e := NewEngine(Options{Node: "node-1", RepeatInterval: time.Hour})
+	_, err := e.PutChannel(Channel{Name: "hook", Type: ChannelWebhook, URL: webhook.URL})
+	require.NoError(t, err)
+	_, err = e.PutChannel(Channel{Name: "chat", Type: ChannelSlack, URL: slack.URL})
+	require.NoError(t, err)
+	_, err = e.PutRule(Rule{Name: "no-peers", Metric: "peers", Op: "<", Threshold: 1, For: "1m", Severity: SeverityCritical, Channels: []string{"hook", "chat"}})
+	require.NoError(t, err)
+	assert.ErrorIs(t, e.DeleteChannel("hook"), ErrInvalid, "the rule uses the channel")
+
+	ctx := context.Background()
+	at := time.Date(2026, 3, 1, 6, 0, 0, 0, time.UTC)
+	step := func(d time.Duration, peers float64) Alert {
+		at = at.Add(d)
+		e.Observe(map[string]float64{"peers": peers}, at)
+		e.Evaluate(ctx, at)
+		return e.Alerts()[0]
+	}
+
+	assert.Equal(t, StateInactive, step(0, 2).State)
+	assert.Equal(t, StatePending, step(15*time.Second, 0).State)
+	assert.Empty(t, webhook.received(), "pending alerts are not notified")
+	a := step(time.Minute, 0)
+	assert.Equal(t, StateFiring, a.State)
+	assert.Equal(t, at, a.FiredAt)
+	require.Len(t, webhook.received(), 1)
+	assert.Equal(t, "firing", webhook.received()[0]["status"])
+	assert.Equal(t, "node-1", webhook.received()[0]["node"])
+	assert.Equal(t, "critical", webhook.received()[0]["severity"])
+	require.Len(t, slack.received(), 1)
+	assert.Equal(t, "[FIRING] no-peers (critical) on node-1: peers < 1, value 0", slack.received()[0]["text"])
+
+	step(30*time.Minute, 0)
+	assert.Len(t, webhook.received(), 1, "notifications repeat after the repeat interval only")
+	step(30*time.Minute, 0)
+	assert.Len(t, webhook.received(), 2)
+
+	a = step(time.Minute, 3)
+	assert.Equal(t, StateInactive, a.State)
+	assert.Equal(t, at, a.ResolvedAt)
+	require.Len(t, webhook.received(), 3)
+	assert.Equal(t, "resolved", webhook.received()[2]["status"])
+	assert.Equal(t, float64(3), webhook.received()[2]["value"])
+}
+
+func TestRateAndAbsenceRules(t *testing.T) {
+	e := NewEngine(Options{})
+	at := time.Date(2026, 3, 1, 6, 0, 0, 0, time.UTC)
+	e.started = at
+	_, err := e.PutRule(Rule{Name: "write-burst", Metric: "writes_total", Kind: KindRate, Op: ">", Threshold: 10, Window: "1m"})
+	require.NoError(t, err)
+	_, err = e.PutRule(Rule{Name: "backup-heartbeat", Metric: "backup_heartbeat", Kind: KindAbsence, Window: "10m"})
+	require.NoError(t, err)
+
+	alerts := func() map[string]Alert {
+		m := make(map[string]Alert)
+		for _, a := range e.Alerts() {
+			m[a.Rule] = a
+		}
+		return m
+	}
+
+	e.Observe(map[string]float64{"writes_total": 100, "backup_heartbeat": 1}, at)
+	e.Evaluate(context.Background(), at)
+	assert.False(t, alerts()["write-burst"].HasValue, "a rate needs two samples")
+
+	at = at.Add(30 * time.Second)
+	e.Observe(map[string]float64{"writes_total": 430}, at)
+	e.Evaluate(context.Background(), at)
+	a := alerts()["write-burst"]
+	assert.Equal(t, StateFiring, a.State)
+	assert.InDelta(t, 11, a.Value, 0.001)
+
+	// The rate is taken over the window, so the burst ages out
+	for range 8 {
+		at = at.Add(15 * time.Second)
+		e.Observe(map[string]float64{"writes_total": 430}, at)
+	}
+	e.Evaluate(context.Background(), at)
+	assert.Equal(t, StateInactive, alerts()["write-burst"].State)
+	assert.Equal(t, StateInactive, alerts()["backup-heartbeat"].State)
+
+	at = at.Add(10 * time.Minute)
+	e.Evaluate(context.Background(), at)
+	a = alerts()["backup-heartbeat"]
+	assert.Equal(t, StateFiring, a.State)
+	assert.Greater(t, a.Value, 600.0)
+
+	e.Observe(map[string]float64{"backup_heartbeat": 1}, at)
+	e.Evaluate(context.Background(), at)
+	assert.Equal(t, StateInactive, alerts()["backup-heartbeat"].State)
+}
+
+func TestSilenceMutesNotifications(t *testing.T) {
+	webhook := newHook(t)
+	e := NewEngine(Options{})
+	_, err := e.PutChannel(Channel{Name: "hook", Type: ChannelWebhook, URL: webhook.URL})
+	require.NoError(t, err)
+	_, err = e.PutRule(Rule{Name: "busy", Metric: "goroutines", Op: ">", Threshold: 100, Channels: []string{"hook"}})
+	require.NoError(t, err)
+	silence, err := e.AddSilence(Silence{Rule: "busy", EndsAt: time.Now().Add(time.Hour), Comment: "load test"})
+	require.NoError(t, err)
+	assert.Len(t, silence.ID, 16)
+
+	now := time.Now()
+	e.Observe(map[string]float64{"goroutines": 500}, now)
+	e.Evaluate(context.Background(), now)
+	a := e.Alerts()[0]
+	assert.Equal(t, StateFiring, a.State, "silenced alerts keep their state")
+	assert.True(t, a.Silenced)
+	assert.Empty(t, webhook.received())
+
+	require.NoError(t, e.DeleteSilence(silence.ID))
+	assert.Empty(t, e.Silences())
+	assert.False(t, e.Alerts()[0].Silenced)
+}
+
+func TestEnginePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	e := NewEngine(Options{Path: path})
+	_, err := e.PutChannel(Channel{Name: "hook", Type: ChannelWebhook, URL: "http://127.0.0.1:1/hook"})
+	require.NoError(t, err)
+	_, err = e.PutRule(Rule{Name: "no-peers", Metric: "peers", Op: "<", Threshold: 1, Window: "1m", Channels: []string{"hook"}})
+	require.NoError(t, err)
+	_, err = e.AddSilence(Silence{EndsAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	now := time.Now()
+	e.Observe(map[string]float64{"peers": 0}, now)
+	e.Evaluate(context.Background(), now)
+	require.Equal(t, StateFiring, e.Alerts()[0].State)
+
+	reloaded := NewEngine(Options{Path: path})
+	require.NoError(t, reloaded.Load())
+	assert.Equal(t, e.Rules(), reloaded.Rules())
+	assert.Equal(t, e.Channels(), reloaded.Channels())
+	assert.Len(t, reloaded.Silences(), 1)
+	a := reloaded.Alerts()[0]
+	assert.Equal(t, StateFiring, a.State)
+	assert.True(t, a.Silenced)
+
+	// Rules keep working after a reload, so resolving sends nothing while silenced
+	reloaded.Observe(map[string]float64{"peers": 2}, now.Add(time.Minute))
+	reloaded.Evaluate(context.Background(), now.Add(time.Minute))
+	assert.Equal(t, StateInactive, reloaded.Alerts()[0].State)
+}
@@ -0,0 +1,253 @@
+// Package alerting evaluates rules over a node's metrics and notifies
+// webhooks, Slack-compatible channels and email recipients when alerts
+// fire and resolve. Rules, channels, silences and alert states persist
+// across restarts.
+package alerting
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for an unknown rule, channel or silence
+	ErrNotFound = errors.New("alerting: not found")
+	// ErrInvalid is returned for rules, channels and silences that cannot
+	// be used
+	ErrInvalid = errors.New("alerting: invalid")
+)
+
+// Kind is how a rule looks at its metric
+type Kind string
+
+const (
+	// KindThreshold compares the latest value with the threshold
+	KindThreshold Kind = "threshold"
+	// KindAbsence fires when the metric was not reported for the window
+	KindAbsence Kind = "absence"
+	// KindRate compares the change per second over the window with the
+	// threshold
+	KindRate Kind = "rate"
+)
+
+// Severity is how urgent an alert is
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// operators compare a value with a threshold
+var operators = map[string]func(v, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+// Rule is a condition on a metric that raises an alert
+type Rule struct {
+	Name   string `json:"name"`
+	Metric string `json:"metric"`
+	Kind   Kind   `json:"kind"`
+	// Op is one of >, >=, <, <=, == and !=; absence rules have none
+	Op        string  `json:"op,omitempty"`
+	Threshold float64 `json:"threshold"`
+	// Window is the span a rate is computed over, or how long the metric
+	// may go unreported; a Go duration such as "5m"
+	Window string `json:"window,omitempty"`
+	// For is how long the condition must hold before the alert fires;
+	// empty fires on the first evaluation that meets it
+	For      string   `json:"for,omitempty"`
+	Severity Severity `json:"severity"`
+	Summary  string   `json:"summary,omitempty"`
+	// Channels are the names of the channels notified
+	Channels []string `json:"channels,omitempty"`
+
+	window, hold time.Duration
+}
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalid, fmt.Sprintf(format, args...))
+}
+
+func parseDuration(field, s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, invalid("%s must be a duration such as 5m", field)
+	}
+	return d, nil
+}
+
+// normalize fills in the defaults of r and checks it
+func (r *Rule) normalize() error {
+	if !namePattern.MatchString(r.Name) {
+		return invalid("name must be letters, digits, dots, dashes or underscores")
+	}
+	if r.Metric == "" {
+		return invalid("metric is required")
+	}
+	if r.Kind == "" {
+		r.Kind = KindThreshold
+	}
+	var err error
+	if r.window, err = parseDuration("window", r.Window); err != nil {
+		return err
+	}
+	if r.hold, err = parseDuration("for", r.For); err != nil {
+		return err
+	}
+	switch r.Kind {
+	case KindThreshold, KindRate:
+		if _, ok := operators[r.Op]; !ok {
+			return invalid("op must be one of >, >=, <, <=, == and !=")
+		}
+		if r.Kind == KindRate && r.window <= 0 {
+			return invalid("rate rules need a window")
+		}
+	case KindAbsence:
+		if r.window <= 0 {
+			return invalid("absence rules need a window")
+		}
+		r.Op = ""
+	default:
+		return invalid("kind must be threshold, absence or rate")
+	}
+	switch r.Severity {
+	case "":
+		r.Severity = SeverityWarning
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return invalid("severity must be info, warning or critical")
+	}
+	return nil
+}
+
+// ChannelType is how a channel is notified
+type ChannelType string
+
+const (
+	// ChannelWebhook receives notifications as JSON
+	ChannelWebhook ChannelType = "webhook"
+	// ChannelSlack receives Slack-compatible {"text": ...} messages, which
+	// Mattermost and Rocket.Chat incoming webhooks accept too
+	ChannelSlack ChannelType = "slack"
+	// ChannelEmail mails notifications through the node's SMTP server
+	ChannelEmail ChannelType = "email"
+)
+
+// Channel is where notifications go
+type Channel struct {
+	Name string      `json:"name"`
+	Type ChannelType `json:"type"`
+	// URL is the webhook of webhook and Slack channels
+	URL string `json:"url,omitempty"`
+	// To are the recipients of email channels
+	To []string `json:"to,omitempty"`
+}
+
+func (c *Channel) normalize() error {
+	if !namePattern.MatchString(c.Name) {
+		return invalid("name must be letters, digits, dots, dashes or underscores")
+	}
+	switch c.Type {
+	case ChannelWebhook, ChannelSlack:
+		u, err := url.Parse(c.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return invalid("%s channels need an http or https URL", c.Type)
+		}
+		c.To = nil
+	case ChannelEmail:
+		if len(c.To) == 0 {
+			return invalid("email channels need recipients")
+		}
+		for _, addr := range c.To {
+			if _, err := mail.ParseAddress(addr); err != nil {
+				return invalid("invalid email address %q", addr)
+			}
+		}
+		c.URL = ""
+	default:
+		return invalid("type must be webhook, slack or email")
+	}
+	return nil
+}
+
+// Silence mutes the notifications of a rule, or of every rule, for a
+// while. Alerts keep their state while silenced.
+type Silence struct {
+	ID string `json:"id"`
+	// Rule is the silenced rule; empty silences every rule
+	Rule      string    `json:"rule,omitempty"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+}
+
+// matches reports whether s mutes rule at t
+func (s Silence) matches(rule string, t time.Time) bool {
+	return (s.Rule == "" || s.Rule == rule) && !t.Before(s.StartsAt) && t.Before(s.EndsAt)
+}
+
+// State is where an alert is in its life cycle
+type State string
+
+const (
+	StateInactive State = "inactive"
+	// StatePending alerts meet their condition but not yet for long enough
+	StatePending State = "pending"
+	StateFiring  State = "firing"
+)
+
+// Alert is the state of a rule
+type Alert struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Summary  string   `json:"summary,omitempty"`
+	State    State    `json:"state"`
+	// Value is the value last evaluated: the metric, its rate or the
+	// seconds since it was reported
+	Value float64 `json:"value"`
+	// HasValue is false while there is no data to evaluate
+	HasValue bool `json:"has_value"`
+	// Since is when the alert entered its state
+	Since        time.Time `json:"since,omitzero"`
+	FiredAt      time.Time `json:"fired_at,omitzero"`
+	ResolvedAt   time.Time `json:"resolved_at,omitzero"`
+	LastNotified time.Time `json:"last_notified,omitzero"`
+	Silenced     bool      `json:"silenced"`
+	// LastError is the last failed notification
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Sample is the latest value of a metric
+type Sample struct {
+	Metric string    `json:"metric"`
+	Value  float64   `json:"value"`
+	Time   time.Time `json:"time"`
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
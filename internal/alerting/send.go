@@ -0,0 +1,98 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/notify"
+)
+
+// Payload is the JSON body posted to webhook channels
+type Payload struct {
+	// Status is firing or resolved
+	Status    string    `json:"status"`
+	Node      string    `json:"node,omitempty"`
+	Rule      string    `json:"rule"`
+	Metric    string    `json:"metric"`
+	Kind      Kind      `json:"kind"`
+	Op        string    `json:"op,omitempty"`
+	Threshold float64   `json:"threshold"`
+	Severity  Severity  `json:"severity"`
+	Summary   string    `json:"summary,omitempty"`
+	Value     float64   `json:"value"`
+	FiredAt   time.Time `json:"fired_at,omitzero"`
+	At        time.Time `json:"at"`
+}
+
+func (e *Engine) payload(n notification) Payload {
+	return Payload{
+		Status:    n.Status,
+		Node:      e.opts.Node,
+		Rule:      n.Rule.Name,
+		Metric:    n.Rule.Metric,
+		Kind:      n.Rule.Kind,
+		Op:        n.Rule.Op,
+		Threshold: n.Rule.Threshold,
+		Severity:  n.Rule.Severity,
+		Summary:   n.Rule.Summary,
+		Value:     n.Value,
+		FiredAt:   n.FiredAt,
+		At:        n.LastNotified,
+	}
+}
+
+// text renders a notification for people, e.g.
+// "[FIRING] disk-full (critical) on node-1: bytes_written_total > 1e+09, value 1.2e+09"
+func (p Payload) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] %s (%s)", strings.ToUpper(p.Status), p.Rule, p.Severity)
+	if p.Node != "" {
+		fmt.Fprintf(&b, " on %s", p.Node)
+	}
+	switch p.Kind {
+	case KindAbsence:
+		fmt.Fprintf(&b, ": %s not reported for %gs", p.Metric, p.Value)
+	case KindRate:
+		fmt.Fprintf(&b, ": rate of %s %s %g/s, value %g/s", p.Metric, p.Op, p.Threshold, p.Value)
+	default:
+		fmt.Fprintf(&b, ": %s %s %g, value %g", p.Metric, p.Op, p.Threshold, p.Value)
+	}
+	if p.Summary != "" {
+		b.WriteString("\n" + p.Summary)
+	}
+	return b.String()
+}
+
+// send notifies every channel of n, returning the joined failures
+func (e *Engine) send(ctx context.Context, n notification) error {
+	p := e.payload(n)
+	var errs []error
+	for _, c := range n.Channels {
+		if err := e.sendTo(ctx, c, p); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e *Engine) sendTo(ctx context.Context, c Channel, p Payload) error {
+	var body any = p
+	switch c.Type {
+	case ChannelEmail:
+		subject := fmt.Sprintf("[%s] PeerVault alert %s", strings.ToUpper(p.Status), p.Rule)
+		return e.opts.SMTP.Mail(c.To, subject, p.text())
+	case ChannelSlack:
+		body = map[string]string{"text": p.text()}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	return notify.Deliver(ctx, e.opts.Queue, e.opts.Client, notify.Webhook{URL: c.URL, ContentType: "application/json", Body: data})
+}
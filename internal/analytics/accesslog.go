@@ -0,0 +1,190 @@
+package analytics
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// accessLogLayout names the access log file of a day
+const accessLogLayout = "accesses-20060102.jsonl"
+
+// maxAccessLine bounds the length of an access log line
+const maxAccessLine = 1 << 20
+
+// writeAccessLog appends the pending accesses to the file of their day and
+// removes the files of days past the retention; callers hold mu
+func (c *Collector) writeAccessLog() error {
+	if c.opts.AccessLogDir == "" {
+		return nil
+	}
+	if len(c.pending) > 0 {
+		if err := os.MkdirAll(c.opts.AccessLogDir, 0700); err != nil {
+			return err
+		}
+		for len(c.pending) > 0 {
+			day := c.pending[0].Time.UTC().Format(accessLogLayout)
+			n := 1
+			for n < len(c.pending) && c.pending[n].Time.UTC().Format(accessLogLayout) == day {
+				n++
+			}
+			if err := appendAccesses(filepath.Join(c.opts.AccessLogDir, day), c.pending[:n]); err != nil {
+				return err
+			}
+			c.pending = c.pending[n:]
+		}
+		c.pending = nil
+	}
+
+	files, err := c.accessLogFiles(time.Time{}, time.Time{})
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().UTC().Add(-c.opts.Retention).Truncate(24 * time.Hour)
+	for _, f := range files {
+		if f.day.Before(cutoff) {
+			if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func appendAccesses(path string, accesses []Access) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, a := range accesses {
+		a.Time = a.Time.UTC()
+		if err := enc.Encode(a); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type accessLogFile struct {
+	path string
+	day  time.Time
+	// size is the length of the file when it was listed
+	size int64
+}
+
+// accessLogFiles returns the access log files of the days overlapping from
+// and to, oldest first; zero times leave the range open
+func (c *Collector) accessLogFiles(from, to time.Time) ([]accessLogFile, error) {
+	entries, err := os.ReadDir(c.opts.AccessLogDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []accessLogFile
+	for _, e := range entries {
+		day, err := time.Parse(accessLogLayout, e.Name())
+		if err != nil || e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if (!from.IsZero() && !day.Add(24*time.Hour).After(from)) || (!to.IsZero() && !day.Before(to)) {
+			continue
+		}
+		files = append(files, accessLogFile{path: filepath.Join(c.opts.AccessLogDir, e.Name()), day: day, size: info.Size()})
+	}
+	slices.SortFunc(files, func(a, b accessLogFile) int { return a.day.Compare(b.day) })
+	return files, nil
+}
+
+// KeepsAccesses reports whether the collector keeps an access log
+func (c *Collector) KeepsAccesses() bool { return c.opts.AccessLogDir != "" }
+
+// Accesses returns the logged accesses from from until to, oldest first.
+// Zero times leave the range open. Without an access log there are none.
+func (c *Collector) Accesses(from, to time.Time) ([]Access, error) {
+	if c.opts.AccessLogDir == "" {
+		return nil, nil
+	}
+	// The files are read up to their length when the pending accesses
+	// were taken, as a flush may append those meanwhile
+	c.mu.Lock()
+	pending := slices.Clone(c.pending)
+	files, err := c.accessLogFiles(from, to)
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	in := func(a Access) bool {
+		return (from.IsZero() || !a.Time.Before(from)) && (to.IsZero() || a.Time.Before(to))
+	}
+	var accesses []Access
+	for _, f := range files {
+		logged, err := readAccesses(f.path, f.size)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range logged {
+			if in(a) {
+				accesses = append(accesses, a)
+			}
+		}
+	}
+	for _, a := range pending {
+		a.Time = a.Time.UTC()
+		if in(a) {
+			accesses = append(accesses, a)
+		}
+	}
+	slices.SortStableFunc(accesses, func(a, b Access) int { return cmp.Compare(a.Time.UnixNano(), b.Time.UnixNano()) })
+	return accesses, nil
+}
+
+// readAccesses reads the first size bytes of an access log file; a file
+// removed past the retention meanwhile has none
+func readAccesses(path string, size int64) ([]Access, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var accesses []Access
+	scanner := bufio.NewScanner(io.LimitReader(f, size))
+	scanner.Buffer(nil, maxAccessLine)
+	for scanner.Scan() {
+		var a Access
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			return nil, fmt.Errorf("analytics: corrupt access log %s: %w", path, err)
+		}
+		accesses = append(accesses, a)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("analytics: reading access log %s: %w", path, err)
+	}
+	return accesses, nil
+}
@@ -0,0 +1,370 @@
+// Package analytics aggregates the accesses a node serves into
+// time-bucketed rollups per key, per tenant and per peer, so dashboards can
+// chart how storage is used without keeping every access.
+package analytics
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBucketSize is the length of a rollup
+	DefaultBucketSize = time.Hour
+	// DefaultRetention is how long rollups are kept
+	DefaultRetention = 30 * 24 * time.Hour
+	// DefaultMaxValues is how many keys, tenants or peers a rollup counts
+	// separately before counting the rest under Other
+	DefaultMaxValues = 10000
+)
+
+// Other is the value accesses are counted under once a rollup holds
+// MaxValues values of their dimension
+const Other = "(other)"
+
+// ErrInvalidDimension is returned for queries by an unknown dimension
+var ErrInvalidDimension = errors.New("analytics: dimension must be key, tenant or peer")
+
+// Op is the kind of an access
+type Op string
+
+const (
+	OpRead   Op = "read"
+	OpWrite  Op = "write"
+	OpDelete Op = "delete"
+)
+
+// Dimension is what accesses are grouped by
+type Dimension string
+
+const (
+	ByKey    Dimension = "key"
+	ByTenant Dimension = "tenant"
+	ByPeer   Dimension = "peer"
+)
+
+// ParseDimension returns the dimension named s
+func ParseDimension(s string) (Dimension, error) {
+	switch d := Dimension(s); d {
+	case ByKey, ByTenant, ByPeer:
+		return d, nil
+	}
+	return "", ErrInvalidDimension
+}
+
+// Access is one read, write or delete served by the node
+type Access struct {
+	Time time.Time `json:"time"`
+	Op   Op        `json:"op"`
+	Key  string    `json:"key"`
+	// Tenant owns the file; empty when unknown
+	Tenant string `json:"tenant,omitempty"`
+	// Peer is the address of the node that made the access; empty for
+	// clients of this node
+	Peer  string `json:"peer,omitempty"`
+	Bytes int64  `json:"bytes,omitempty"`
+}
+
+// Counters count the accesses of a rollup
+type Counters struct {
+	Reads        int64 `json:"reads"`
+	Writes       int64 `json:"writes"`
+	Deletes      int64 `json:"deletes"`
+	BytesRead    int64 `json:"bytes_read"`
+	BytesWritten int64 `json:"bytes_written"`
+}
+
+// Total returns the number of accesses
+func (c Counters) Total() int64 { return c.Reads + c.Writes + c.Deletes }
+
+func (c *Counters) add(o Counters) {
+	c.Reads += o.Reads
+	c.Writes += o.Writes
+	c.Deletes += o.Deletes
+	c.BytesRead += o.BytesRead
+	c.BytesWritten += o.BytesWritten
+}
+
+func (c *Counters) count(a Access) {
+	switch a.Op {
+	case OpRead:
+		c.Reads++
+		c.BytesRead += a.Bytes
+	case OpWrite:
+		c.Writes++
+		c.BytesWritten += a.Bytes
+	case OpDelete:
+		c.Deletes++
+	}
+}
+
+// Rollup counts the accesses to one key, tenant or peer during a bucket
+type Rollup struct {
+	Start     time.Time `json:"start"`
+	Dimension Dimension `json:"dimension"`
+	Value     string    `json:"value"`
+	Counters
+}
+
+// Total counts the accesses to one key, tenant or peer over a time range
+type Total struct {
+	Value string `json:"value"`
+	Counters
+}
+
+// Query selects rollups
+type Query struct {
+	Dimension Dimension
+	// Value restricts the rollups to one key, tenant or peer
+	Value string
+	// From and To select the rollups whose bucket overlaps them; zero
+	// leaves them open
+	From, To time.Time
+}
+
+// Options configures a collector
+type Options struct {
+	// Path persists the rollups; empty keeps them in memory
+	Path string
+	// BucketSize is the length of a rollup; zero uses DefaultBucketSize
+	BucketSize time.Duration
+	// Retention is how long rollups are kept; zero uses DefaultRetention
+	Retention time.Duration
+	// MaxValues bounds the values a rollup counts separately per
+	// dimension; zero uses DefaultMaxValues
+	MaxValues int
+	// AccessLogDir keeps every access, in a file of JSON lines per UTC
+	// day, for as long as the rollups; empty keeps none
+	AccessLogDir string
+}
+
+type bucket map[Dimension]map[string]*Counters
+
+// Collector aggregates accesses into rollups
+type Collector struct {
+	mu      sync.Mutex
+	opts    Options
+	buckets map[int64]bucket
+	totals  Counters
+	dirty   bool
+	// pending holds the accesses not yet written to the access log
+	pending []Access
+}
+
+// NewCollector creates a collector
+func NewCollector(opts Options) *Collector {
+	if opts.BucketSize <= 0 {
+		opts.BucketSize = DefaultBucketSize
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = DefaultRetention
+	}
+	if opts.MaxValues <= 0 {
+		opts.MaxValues = DefaultMaxValues
+	}
+	return &Collector{opts: opts, buckets: make(map[int64]bucket)}
+}
+
+// BucketSize returns the length of a rollup
+func (c *Collector) BucketSize() time.Duration { return c.opts.BucketSize }
+
+// Record counts an access in the rollups of its key, tenant and peer.
+// Accesses without a time are counted now.
+func (c *Collector) Record(a Access) {
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+	start := a.Time.Truncate(c.opts.BucketSize).Unix()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.buckets[start]
+	if !ok {
+		b = make(bucket)
+		c.buckets[start] = b
+	}
+	for _, dv := range [...]struct {
+		dim   Dimension
+		value string
+	}{{ByKey, a.Key}, {ByTenant, a.Tenant}, {ByPeer, a.Peer}} {
+		dim, value := dv.dim, dv.value
+		if value == "" {
+			continue
+		}
+		values, ok := b[dim]
+		if !ok {
+			values = make(map[string]*Counters)
+			b[dim] = values
+		}
+		counters, ok := values[value]
+		if !ok {
+			if len(values) >= c.opts.MaxValues {
+				value = Other
+			}
+			if counters, ok = values[value]; !ok {
+				counters = &Counters{}
+				values[value] = counters
+			}
+		}
+		counters.count(a)
+	}
+	c.totals.count(a)
+	c.dirty = true
+	if c.opts.AccessLogDir != "" {
+		c.pending = append(c.pending, a)
+	}
+}
+
+// Totals counts every access recorded since the collector was created
+func (c *Collector) Totals() Counters {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.totals
+}
+
+// Query returns the rollups selected by q, oldest first and by value
+// within a bucket
+func (c *Collector) Query(q Query) ([]Rollup, error) {
+	if _, err := ParseDimension(string(q.Dimension)); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rollups := []Rollup{}
+	for start, b := range c.buckets {
+		t := time.Unix(start, 0).UTC()
+		if (!q.From.IsZero() && !t.Add(c.opts.BucketSize).After(q.From)) || (!q.To.IsZero() && !t.Before(q.To)) {
+			continue
+		}
+		for value, counters := range b[q.Dimension] {
+			if q.Value == "" || q.Value == value {
+				rollups = append(rollups, Rollup{Start: t, Dimension: q.Dimension, Value: value, Counters: *counters})
+			}
+		}
+	}
+	slices.SortFunc(rollups, func(a, b Rollup) int {
+		return cmp.Or(a.Start.Compare(b.Start), cmp.Compare(a.Value, b.Value))
+	})
+	return rollups, nil
+}
+
+// Top returns the values of a dimension with the most accesses in the
+// rollups selected by q, at most limit of them when limit is positive
+func (c *Collector) Top(q Query, limit int) ([]Total, error) {
+	rollups, err := c.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	sums := make(map[string]*Counters)
+	for _, r := range rollups {
+		sum, ok := sums[r.Value]
+		if !ok {
+			sum = &Counters{}
+			sums[r.Value] = sum
+		}
+		sum.add(r.Counters)
+	}
+	totals := make([]Total, 0, len(sums))
+	for value, sum := range sums {
+		totals = append(totals, Total{Value: value, Counters: *sum})
+	}
+	slices.SortFunc(totals, func(a, b Total) int {
+		return cmp.Or(cmp.Compare(b.Total(), a.Total()), cmp.Compare(b.BytesRead+b.BytesWritten, a.BytesRead+a.BytesWritten), cmp.Compare(a.Value, b.Value))
+	})
+	if limit > 0 && len(totals) > limit {
+		totals = totals[:limit]
+	}
+	return totals, nil
+}
+
+// Flush drops the rollups older than the retention and persists the rest
+// when accesses were recorded since the last flush. The accesses recorded
+// since are appended to the access log.
+func (c *Collector) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.writeAccessLog(); err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-c.opts.Retention).Unix()
+	for start := range c.buckets {
+		if start < cutoff {
+			delete(c.buckets, start)
+			c.dirty = true
+		}
+	}
+	if !c.dirty || c.opts.Path == "" {
+		return nil
+	}
+	var rollups []Rollup
+	for start, b := range c.buckets {
+		for dim, values := range b {
+			for value, counters := range values {
+				rollups = append(rollups, Rollup{Start: time.Unix(start, 0).UTC(), Dimension: dim, Value: value, Counters: *counters})
+			}
+		}
+	}
+	data, err := json.Marshal(rollups)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.opts.Path), 0700); err != nil {
+		return err
+	}
+	tmp := c.opts.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.opts.Path); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// Load reads the persisted rollups, adding them to those recorded so far
+func (c *Collector) Load() error {
+	if c.opts.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var rollups []Rollup
+	if err := json.Unmarshal(data, &rollups); err != nil {
+		return fmt.Errorf("analytics: corrupt rollups %s: %w", c.opts.Path, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range rollups {
+		start := r.Start.Truncate(c.opts.BucketSize).Unix()
+		b, ok := c.buckets[start]
+		if !ok {
+			b = make(bucket)
+			c.buckets[start] = b
+		}
+		values, ok := b[r.Dimension]
+		if !ok {
+			values = make(map[string]*Counters)
+			b[r.Dimension] = values
+		}
+		counters, ok := values[r.Value]
+		if !ok {
+			counters = &Counters{}
+			values[r.Value] = counters
+		}
+		counters.add(r.Counters)
+	}
+	return nil
+}
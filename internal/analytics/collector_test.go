@@ -0,0 +1,120 @@
+package analytics
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectorRollsUpAccesses(t *testing.T) {
+	c := NewCollector(Options{})
+	hour := time.Now().Truncate(time.Hour).Add(-2 * time.Hour)
+	c.Record(Access{Time: hour, Op: OpWrite, Key: "a.txt", Tenant: "acme", Bytes: 100})
+	c.Record(Access{Time: hour.Add(time.Minute), Op: OpRead, Key: "a.txt", Tenant: "acme", Bytes: 100})
+	c.Record(Access{Time: hour.Add(2 * time.Minute), Op: OpRead, Key: "a.txt", Peer: "10.0.0.2:3000", Bytes: 100})
+	c.Record(Access{Time: hour.Add(time.Hour), Op: OpDelete, Key: "b.txt", Tenant: "globex"})
+
+	rollups, err := c.Query(Query{Dimension: ByKey})
+	require.NoError(t, err)
+	require.Len(t, rollups, 2)
+	assert.Equal(t, Rollup{Start: hour.UTC(), Dimension: ByKey, Value: "a.txt", Counters: Counters{Reads: 2, Writes: 1, BytesRead: 200, BytesWritten: 100}}, rollups[0])
+	assert.Equal(t, "b.txt", rollups[1].Value)
+	assert.Equal(t, int64(1), rollups[1].Deletes)
+
+	rollups, err = c.Query(Query{Dimension: ByTenant, Value: "acme"})
+	require.NoError(t, err)
+	require.Len(t, rollups, 1)
+	assert.Equal(t, int64(2), rollups[0].Total(), "the peer's read has no tenant")
+
+	rollups, err = c.Query(Query{Dimension: ByPeer})
+	require.NoError(t, err)
+	require.Len(t, rollups, 1)
+	assert.Equal(t, "10.0.0.2:3000", rollups[0].Value)
+
+	// Buckets overlapping the range are selected
+	rollups, err = c.Query(Query{Dimension: ByKey, From: hour.Add(30 * time.Minute), To: hour.Add(time.Hour)})
+	require.NoError(t, err)
+	require.Len(t, rollups, 1)
+	assert.Equal(t, "a.txt", rollups[0].Value)
+
+	_, err = c.Query(Query{Dimension: "bucket"})
+	assert.ErrorIs(t, err, ErrInvalidDimension)
+
+	assert.Equal(t, Counters{Reads: 2, Writes: 1, Deletes: 1, BytesRead: 200, BytesWritten: 100}, c.Totals())
+}
+
+func TestCollectorTop(t *testing.T) {
+	c := NewCollector(Options{MaxValues: 2})
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		c.Record(Access{Time: now, Op: OpRead, Key: "hot"})
+	}
+	c.Record(Access{Time: now, Op: OpRead, Key: "warm"})
+	c.Record(Access{Time: now, Op: OpRead, Key: "cold"})
+	c.Record(Access{Time: now, Op: OpRead, Key: "frozen"})
+	c.Record(Access{Time: now.Add(-time.Hour), Op: OpRead, Key: "warm"})
+
+	top, err := c.Top(Query{Dimension: ByKey}, 2)
+	require.NoError(t, err)
+	require.Len(t, top, 2)
+	assert.Equal(t, Total{Value: "hot", Counters: Counters{Reads: 3}}, top[0])
+	assert.Equal(t, "(other)", top[1].Value, "keys past MaxValues are counted together")
+	assert.Equal(t, int64(2), top[1].Reads)
+
+	top, err = c.Top(Query{Dimension: ByKey, From: now.Add(-time.Hour), To: now.Add(-time.Hour).Add(time.Second)}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []Total{{Value: "warm", Counters: Counters{Reads: 1}}}, top)
+}
+
+func TestCollectorPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "analytics.json")
+	c := NewCollector(Options{Path: path, Retention: 24 * time.Hour})
+	c.Record(Access{Op: OpWrite, Key: "a.txt", Tenant: "acme", Bytes: 10})
+	c.Record(Access{Time: time.Now().Add(-48 * time.Hour), Op: OpRead, Key: "old.txt"})
+	require.NoError(t, c.Flush())
+
+	reloaded := NewCollector(Options{Path: path})
+	require.NoError(t, reloaded.Load())
+	rollups, err := reloaded.Query(Query{Dimension: ByKey})
+	require.NoError(t, err)
+	require.Len(t, rollups, 1, "rollups past the retention are dropped")
+	assert.Equal(t, int64(10), rollups[0].BytesWritten)
+	rollups, err = reloaded.Query(Query{Dimension: ByTenant})
+	require.NoError(t, err)
+	assert.Len(t, rollups, 1)
+}
+
+func TestCollectorAccessLog(t *testing.T) {
+	dir := t.TempDir()
+	c := NewCollector(Options{AccessLogDir: dir, Retention: 72 * time.Hour})
+	now := time.Now().UTC()
+	c.Record(Access{Time: now.Add(-24 * time.Hour), Op: OpWrite, Key: "a.txt", Tenant: "acme", Bytes: 10})
+	c.Record(Access{Time: now.Add(-10 * 24 * time.Hour), Op: OpRead, Key: "old.txt"})
+	c.Record(Access{Time: now, Op: OpRead, Key: "a.txt", Peer: "10.0.0.2:3000", Bytes: 10})
+	require.NoError(t, c.Flush())
+	c.Record(Access{Time: now.Add(time.Second), Op: OpDelete, Key: "a.txt"})
+
+	accesses, err := c.Accesses(time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, accesses, 3, "days past the retention are removed, pending accesses are included")
+	assert.Equal(t, Access{Time: now.Add(-24 * time.Hour), Op: OpWrite, Key: "a.txt", Tenant: "acme", Bytes: 10}, accesses[0])
+	assert.Equal(t, "10.0.0.2:3000", accesses[1].Peer)
+	assert.Equal(t, OpDelete, accesses[2].Op)
+
+	accesses, err = c.Accesses(now.Add(-time.Hour), now.Add(time.Second))
+	require.NoError(t, err)
+	require.Len(t, accesses, 1)
+	assert.Equal(t, OpRead, accesses[0].Op)
+
+	require.NoError(t, c.Flush())
+	accesses, err = NewCollector(Options{AccessLogDir: dir}).Accesses(time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.Len(t, accesses, 3)
+
+	accesses, err = NewCollector(Options{}).Accesses(time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, accesses)
+}
@@ -0,0 +1,104 @@
+package analytics
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Page layout of rendered PDFs: A4 portrait in points, set in Courier so
+// tabular text stays aligned
+const (
+	pdfPageWidth    = 595
+	pdfPageHeight   = 842
+	pdfMargin       = 40
+	pdfFontSize     = 8
+	pdfLeading      = 10
+	pdfTitleSize    = 12
+	pdfLinesPerPage = (pdfPageHeight - 2*pdfMargin - 2*pdfLeading) / pdfLeading
+	// pdfLineChars is how many Courier characters fit the width, each
+	// being 0.6 of the font size wide
+	pdfLineChars = (pdfPageWidth - 2*pdfMargin) * 10 / (6 * pdfFontSize)
+)
+
+// writePDF lays out a title and lines of text as a PDF document, the title
+// on every page. Lines too long for the page are cut off.
+func writePDF(title string, lines []string) []byte {
+	var pages [][]string
+	for len(lines) > pdfLinesPerPage {
+		pages = append(pages, lines[:pdfLinesPerPage])
+		lines = lines[pdfLinesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	// Objects 1 to 3 are the catalog, the page tree and the font, followed
+	// by a page and its content stream per page
+	var objects []string
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+	)
+	for i, page := range pages {
+		var content bytes.Buffer
+		y := pdfPageHeight - pdfMargin - pdfTitleSize
+		fmt.Fprintf(&content, "BT /F1 %d Tf %d %d Td (%s) Tj ET\n", pdfTitleSize, pdfMargin, y, pdfEscape(title))
+		y -= 2 * pdfLeading
+		fmt.Fprintf(&content, "BT /F1 %d Tf %d TL %d %d Td\n", pdfFontSize, pdfLeading, pdfMargin, y)
+		for _, line := range page {
+			if r := []rune(line); len(r) > pdfLineChars {
+				line = string(r[:pdfLineChars])
+			}
+			fmt.Fprintf(&content, "(%s) Tj T*\n", pdfEscape(line))
+		}
+		fmt.Fprintf(&content, "ET\n")
+		fmt.Fprintf(&content, "BT /F1 %d Tf %d %d Td (Page %d of %d) Tj ET\n", pdfFontSize, pdfMargin, pdfMargin/2, i+1, len(pages))
+
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+				pdfPageWidth, pdfPageHeight, 5+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes()
+}
+
+// pdfEscape quotes s as the body of a PDF literal string. Characters
+// outside Latin-1 cannot be shown by the standard fonts and become '?'.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20:
+			b.WriteByte(' ')
+		case r > 0xff:
+			b.WriteByte('?')
+		case r > 0x7e:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
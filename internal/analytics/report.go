@@ -0,0 +1,331 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/backup"
+)
+
+// DefaultReportRange is the time range a report covers when it sets none
+const DefaultReportRange = 24 * time.Hour
+
+// DefaultReportPrefix is the key prefix reports are stored under when they
+// set none
+const DefaultReportPrefix = "reports/"
+
+var (
+	// ErrReportNotFound is returned for an unknown report name
+	ErrReportNotFound = errors.New("analytics: report not found")
+	// ErrInvalidReport is returned for report definitions that cannot run
+	ErrInvalidReport = errors.New("analytics: invalid report")
+)
+
+// Format is what a report is rendered to
+type Format string
+
+const (
+	FormatCSV  Format = "csv"
+	FormatJSON Format = "json"
+	FormatPDF  Format = "pdf"
+)
+
+// ContentType returns the media type of the format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatJSON:
+		return "application/json"
+	case FormatPDF:
+		return "application/pdf"
+	}
+	return "text/csv"
+}
+
+// Grouping is how a report groups the accesses of its range
+type Grouping string
+
+const (
+	// GroupByBucket reports one row per rollup, so per value and bucket
+	GroupByBucket Grouping = "bucket"
+	// GroupByTotal reports one row per value, the most accessed first
+	GroupByTotal Grouping = "total"
+)
+
+// Metric is a counter a report shows
+type Metric string
+
+const (
+	MetricReads        Metric = "reads"
+	MetricWrites       Metric = "writes"
+	MetricDeletes      Metric = "deletes"
+	MetricBytesRead    Metric = "bytes_read"
+	MetricBytesWritten Metric = "bytes_written"
+	MetricTotal        Metric = "total"
+)
+
+// allMetrics are the metrics of reports that name none
+var allMetrics = []Metric{MetricReads, MetricWrites, MetricDeletes, MetricBytesRead, MetricBytesWritten, MetricTotal}
+
+// Metrics returns every metric
+func Metrics() []Metric { return slices.Clone(allMetrics) }
+
+// Value returns the counter m shows, and false for an unknown metric
+func (m Metric) Value(c Counters) (int64, bool) {
+	switch m {
+	case MetricReads:
+		return c.Reads, true
+	case MetricWrites:
+		return c.Writes, true
+	case MetricDeletes:
+		return c.Deletes, true
+	case MetricBytesRead:
+		return c.BytesRead, true
+	case MetricBytesWritten:
+		return c.BytesWritten, true
+	case MetricTotal:
+		return c.Total(), true
+	}
+	return 0, false
+}
+
+// Report defines a report on the access rollups: which metrics, over which
+// range and grouping, when it runs and where its output goes
+type Report struct {
+	Name      string    `json:"name"`
+	Dimension Dimension `json:"dimension"`
+	// Value restricts the report to one key, tenant or peer
+	Value    string   `json:"value,omitempty"`
+	Metrics  []Metric `json:"metrics,omitempty"`
+	Grouping Grouping `json:"grouping,omitempty"`
+	// Range is how far back from the run the report looks, as a Go
+	// duration such as "168h"; empty uses DefaultReportRange
+	Range string `json:"range,omitempty"`
+	// Limit bounds the rows of a report grouped by total; zero reports
+	// every value
+	Limit int `json:"limit,omitempty"`
+	// Schedule is the cron expression the report runs on; empty runs it
+	// on demand only
+	Schedule string `json:"schedule,omitempty"`
+	Format   Format `json:"format,omitempty"`
+	// Prefix is the key prefix the output is stored under, followed by the
+	// report name and the time of the run
+	Prefix string `json:"prefix,omitempty"`
+	// Webhook receives the output in a POST when set
+	Webhook string `json:"webhook,omitempty"`
+	// Email lists the addresses the output is mailed to
+	Email []string `json:"email,omitempty"`
+}
+
+var reportNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// normalize fills in the defaults of r and checks that it can run
+func (r *Report) normalize() (*backup.Schedule, error) {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidReport, fmt.Sprintf(format, args...))
+	}
+	if !reportNamePattern.MatchString(r.Name) {
+		return nil, invalid("name must be letters, digits, dots, dashes or underscores")
+	}
+	if r.Dimension == "" {
+		r.Dimension = ByKey
+	}
+	if _, err := ParseDimension(string(r.Dimension)); err != nil {
+		return nil, invalid("dimension must be key, tenant or peer")
+	}
+	if len(r.Metrics) == 0 {
+		r.Metrics = allMetrics
+	}
+	for _, m := range r.Metrics {
+		if _, ok := m.Value(Counters{}); !ok {
+			return nil, invalid("unknown metric %q", m)
+		}
+	}
+	switch r.Grouping {
+	case "":
+		r.Grouping = GroupByBucket
+	case GroupByBucket, GroupByTotal:
+	default:
+		return nil, invalid("grouping must be bucket or total")
+	}
+	if r.Range == "" {
+		r.Range = DefaultReportRange.String()
+	}
+	if d, err := time.ParseDuration(r.Range); err != nil || d <= 0 {
+		return nil, invalid("range must be a positive duration such as 24h")
+	}
+	if r.Limit < 0 {
+		return nil, invalid("limit must not be negative")
+	}
+	switch r.Format {
+	case "":
+		r.Format = FormatCSV
+	case FormatCSV, FormatJSON, FormatPDF:
+	default:
+		return nil, invalid("format must be csv, json or pdf")
+	}
+	if r.Prefix == "" {
+		r.Prefix = DefaultReportPrefix
+	}
+	if r.Webhook != "" {
+		u, err := url.Parse(r.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, invalid("webhook must be an http or https URL")
+		}
+	}
+	for _, addr := range r.Email {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return nil, invalid("invalid email address %q", addr)
+		}
+	}
+	if r.Schedule == "" {
+		return nil, nil
+	}
+	schedule, err := backup.ParseSchedule(r.Schedule)
+	if err != nil {
+		return nil, invalid("%v", err)
+	}
+	return schedule, nil
+}
+
+// table is a rendered report before formatting
+type table struct {
+	title   string
+	from    time.Time
+	to      time.Time
+	columns []string
+	// rows hold strings, times and counts
+	rows [][]any
+}
+
+// Render runs r against the rollups of c for the range ending at now and
+// formats the result. r must have been accepted by Reports.Put.
+func Render(c *Collector, r Report, now time.Time) ([]byte, error) {
+	window, err := time.ParseDuration(r.Range)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidReport, err)
+	}
+	t := table{
+		title: fmt.Sprintf("%s: accesses by %s", r.Name, r.Dimension),
+		from:  now.Add(-window).UTC(),
+		to:    now.UTC(),
+	}
+	q := Query{Dimension: r.Dimension, Value: r.Value, From: t.from, To: t.to}
+	metrics := func(counters Counters) []any {
+		values := make([]any, len(r.Metrics))
+		for i, m := range r.Metrics {
+			values[i], _ = m.Value(counters)
+		}
+		return values
+	}
+	switch r.Grouping {
+	case GroupByTotal:
+		totals, err := c.Top(q, r.Limit)
+		if err != nil {
+			return nil, err
+		}
+		t.columns = []string{string(r.Dimension)}
+		for _, total := range totals {
+			t.rows = append(t.rows, append([]any{total.Value}, metrics(total.Counters)...))
+		}
+	default:
+		rollups, err := c.Query(q)
+		if err != nil {
+			return nil, err
+		}
+		t.columns = []string{"start", string(r.Dimension)}
+		for _, rollup := range rollups {
+			t.rows = append(t.rows, append([]any{rollup.Start, rollup.Value}, metrics(rollup.Counters)...))
+		}
+	}
+	for _, m := range r.Metrics {
+		t.columns = append(t.columns, string(m))
+	}
+
+	switch r.Format {
+	case FormatJSON:
+		return t.json()
+	case FormatPDF:
+		return t.pdf(), nil
+	}
+	return t.csv()
+}
+
+func cell(v any) string {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	}
+	return fmt.Sprint(v)
+}
+
+func (t table) csv() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(t.columns); err != nil {
+		return nil, err
+	}
+	for _, row := range t.rows {
+		record := make([]string, len(row))
+		for i, v := range row {
+			record[i] = cell(v)
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func (t table) json() ([]byte, error) {
+	rows := make([]map[string]any, len(t.rows))
+	for i, row := range t.rows {
+		rows[i] = make(map[string]any, len(row))
+		for j, v := range row {
+			rows[i][t.columns[j]] = v
+		}
+	}
+	return json.MarshalIndent(struct {
+		Title string           `json:"title"`
+		From  time.Time        `json:"from"`
+		To    time.Time        `json:"to"`
+		Rows  []map[string]any `json:"rows"`
+	}{t.title, t.from, t.to, rows}, "", "  ")
+}
+
+func (t table) pdf() []byte {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(t.columns, "\t"))
+	for _, row := range t.rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			cells[i] = cell(v)
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	w.Flush()
+	lines := []string{
+		fmt.Sprintf("From %s to %s", t.from.Format(time.RFC3339), t.to.Format(time.RFC3339)),
+		"",
+	}
+	if len(t.rows) == 0 {
+		lines = append(lines, "No accesses recorded")
+	} else {
+		lines = append(lines, strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")...)
+	}
+	return writePDF(t.title, lines)
+}
@@ -0,0 +1,299 @@
+package analytics
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/backup"
+	"github.com/Skpow1234/Peervault/internal/notify"
+	"github.com/Skpow1234/Peervault/internal/queue"
+)
+
+// reportDeliveryTimeout bounds a report's webhook call
+const reportDeliveryTimeout = 30 * time.Second
+
+// StoreFunc stores the output of a report run under key
+type StoreFunc func(ctx context.Context, key string, data []byte) error
+
+// ReportStatus is the outcome of a report's last run and when it runs next
+type ReportStatus struct {
+	LastRun   time.Time `json:"last_run,omitempty"`
+	LastKey   string    `json:"last_key,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	NextRun   time.Time `json:"next_run,omitempty"`
+}
+
+// ReportInfo is a report definition with its status
+type ReportInfo struct {
+	Report
+	ReportStatus
+}
+
+// ReportRun is the outcome of running a report
+type ReportRun struct {
+	Report      string    `json:"report"`
+	Key         string    `json:"key"`
+	Format      Format    `json:"format"`
+	Size        int       `json:"size"`
+	GeneratedAt time.Time `json:"generated_at"`
+	// Errors lists the deliveries that failed; the output was stored
+	// regardless
+	Errors []string `json:"errors,omitempty"`
+}
+
+// ReportsOptions configures the reports of a node
+type ReportsOptions struct {
+	// Path persists the definitions and their status; empty keeps them in
+	// memory
+	Path string
+	// SMTP is the mail server used for reports with email recipients
+	SMTP notify.SMTPConfig
+	// Client posts to webhooks; nil uses a client with a timeout
+	Client *http.Client
+	// Queue, when set, delivers webhooks in jobs retried until they
+	// succeed rather than posting them once
+	Queue *queue.Queue
+}
+
+type reportEntry struct {
+	ReportInfo
+	schedule *backup.Schedule
+}
+
+// Reports keeps report definitions, runs them and tracks which are due
+type Reports struct {
+	collector *Collector
+	opts      ReportsOptions
+	now       func() time.Time
+
+	mu      sync.Mutex
+	reports map[string]*reportEntry
+}
+
+// NewReports creates the reports on the rollups of c
+func NewReports(c *Collector, opts ReportsOptions) *Reports {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: reportDeliveryTimeout}
+	}
+	return &Reports{
+		collector: c,
+		opts:      opts,
+		now:       time.Now,
+		reports:   make(map[string]*reportEntry),
+	}
+}
+
+// Put creates or replaces a report, filling in its defaults
+func (rs *Reports) Put(r Report) (ReportInfo, error) {
+	schedule, err := r.normalize()
+	if err != nil {
+		return ReportInfo{}, err
+	}
+	if len(r.Email) > 0 && !rs.opts.SMTP.Enabled() {
+		return ReportInfo{}, fmt.Errorf("%w: email needs an SMTP server configured on the node", ErrInvalidReport)
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	entry := &reportEntry{ReportInfo: ReportInfo{Report: r}, schedule: schedule}
+	if old, ok := rs.reports[r.Name]; ok {
+		entry.ReportStatus = old.ReportStatus
+	}
+	entry.NextRun = time.Time{}
+	if schedule != nil {
+		entry.NextRun = schedule.Next(rs.now())
+	}
+	rs.reports[r.Name] = entry
+	return entry.ReportInfo, rs.save()
+}
+
+// Get returns a report by name
+func (rs *Reports) Get(name string) (ReportInfo, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	entry, ok := rs.reports[name]
+	if !ok {
+		return ReportInfo{}, fmt.Errorf("%w: %s", ErrReportNotFound, name)
+	}
+	return entry.ReportInfo, nil
+}
+
+// List returns every report by name
+func (rs *Reports) List() []ReportInfo {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	infos := make([]ReportInfo, 0, len(rs.reports))
+	for _, entry := range rs.reports {
+		infos = append(infos, entry.ReportInfo)
+	}
+	slices.SortFunc(infos, func(a, b ReportInfo) int { return cmp.Compare(a.Name, b.Name) })
+	return infos
+}
+
+// Delete removes a report; the outputs it stored are kept
+func (rs *Reports) Delete(name string) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if _, ok := rs.reports[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrReportNotFound, name)
+	}
+	delete(rs.reports, name)
+	return rs.save()
+}
+
+// Due returns the scheduled reports whose next run is not after now, by
+// name, and schedules their following run. Runs are recorded in memory
+// even when they cannot be persisted.
+func (rs *Reports) Due(now time.Time) []string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	var due []string
+	for name, entry := range rs.reports {
+		if entry.schedule == nil || entry.NextRun.IsZero() || entry.NextRun.After(now) {
+			continue
+		}
+		due = append(due, name)
+		entry.NextRun = entry.schedule.Next(now)
+	}
+	if len(due) > 0 {
+		_ = rs.save()
+	}
+	slices.Sort(due)
+	return due
+}
+
+// Run renders a report over the range ending now, stores the output with
+// store and delivers it to the report's webhook and email recipients.
+// Failed deliveries are listed in the run rather than failing it; webhooks
+// delivered through a queue fail there.
+func (rs *Reports) Run(ctx context.Context, name string, store StoreFunc) (*ReportRun, error) {
+	r, err := rs.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	now := rs.now()
+	run := &ReportRun{
+		Report:      name,
+		Key:         fmt.Sprintf("%s%s/%s.%s", r.Prefix, name, now.UTC().Format("20060102T150405Z"), r.Format),
+		Format:      r.Format,
+		GeneratedAt: now,
+	}
+	data, err := Render(rs.collector, r.Report, now)
+	if err == nil {
+		run.Size = len(data)
+		err = store(ctx, run.Key, data)
+	}
+	if err != nil {
+		rs.finish(name, now, "", err)
+		return nil, err
+	}
+
+	if r.Webhook != "" {
+		ctx, cancel := context.WithTimeout(ctx, reportDeliveryTimeout)
+		header := http.Header{"X-PeerVault-Report": {name}, "X-PeerVault-Key": {run.Key}}
+		webhook := notify.Webhook{URL: r.Webhook, ContentType: r.Format.ContentType(), Header: header, Body: data}
+		if err := notify.Deliver(ctx, rs.opts.Queue, rs.opts.Client, webhook); err != nil {
+			run.Errors = append(run.Errors, fmt.Sprintf("webhook: %v", err))
+		}
+		cancel()
+	}
+	if len(r.Email) > 0 {
+		text := fmt.Sprintf("Report %s generated at %s is attached and stored as %s.", name, now.UTC().Format(time.RFC3339), run.Key)
+		attachment := notify.Attachment{Filename: path.Base(run.Key), ContentType: r.Format.ContentType(), Data: data}
+		if err := rs.opts.SMTP.Mail(r.Email, "PeerVault report "+name, text, attachment); err != nil {
+			run.Errors = append(run.Errors, fmt.Sprintf("email: %v", err))
+		}
+	}
+	var deliveryErr error
+	if len(run.Errors) > 0 {
+		deliveryErr = errors.New(run.Errors[0])
+	}
+	rs.finish(name, now, run.Key, deliveryErr)
+	return run, nil
+}
+
+// finish records the outcome of a run, unless the report was deleted
+// meanwhile, keeping it in memory even when it cannot be persisted
+func (rs *Reports) finish(name string, at time.Time, key string, err error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	entry, ok := rs.reports[name]
+	if !ok {
+		return
+	}
+	entry.LastRun = at
+	if key != "" {
+		entry.LastKey = key
+	}
+	entry.LastError = ""
+	if err != nil {
+		entry.LastError = err.Error()
+	}
+	_ = rs.save()
+}
+
+// save persists the reports; callers hold mu
+func (rs *Reports) save() error {
+	if rs.opts.Path == "" {
+		return nil
+	}
+	infos := make([]ReportInfo, 0, len(rs.reports))
+	for _, entry := range rs.reports {
+		infos = append(infos, entry.ReportInfo)
+	}
+	data, err := json.Marshal(infos)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(rs.opts.Path), 0700); err != nil {
+		return err
+	}
+	tmp := rs.opts.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, rs.opts.Path)
+}
+
+// Load reads the persisted reports. A scheduled run missed while the node
+// was down is due right away.
+func (rs *Reports) Load() error {
+	if rs.opts.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(rs.opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var infos []ReportInfo
+	if err := json.Unmarshal(data, &infos); err != nil {
+		return fmt.Errorf("analytics: corrupt reports %s: %w", rs.opts.Path, err)
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, info := range infos {
+		schedule, err := info.normalize()
+		if err != nil {
+			return fmt.Errorf("analytics: report %s: %w", info.Name, err)
+		}
+		entry := &reportEntry{ReportInfo: info, schedule: schedule}
+		if schedule != nil && entry.NextRun.IsZero() {
+			entry.NextRun = schedule.Next(rs.now())
+		}
+		rs.reports[info.Name] = entry
+	}
+	return nil
+}
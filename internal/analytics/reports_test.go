@@ -0,0 +1,150 @@
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/search"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportValidation(t *testing.T) {
+	rs := NewReports(NewCollector(Options{}), ReportsOptions{})
+	info, err := rs.Put(Report{Name: "daily"})
+	require.NoError(t, err)
+	assert.Equal(t, ByKey, info.Dimension)
+	assert.Equal(t, GroupByBucket, info.Grouping)
+	assert.Equal(t, FormatCSV, info.Format)
+	assert.Equal(t, "24h0m0s", info.Range)
+	assert.Equal(t, DefaultReportPrefix, info.Prefix)
+	assert.Len(t, info.Metrics, 6)
+	assert.True(t, info.NextRun.IsZero(), "reports without a schedule only run on demand")
+
+	for _, r := range []Report{
+		{Name: "../up"},
+		{Name: "r", Dimension: "bucket"},
+		{Name: "r", Metrics: []Metric{"latency"}},
+		{Name: "r", Grouping: "day"},
+		{Name: "r", Range: "-1h"},
+		{Name: "r", Format: "xlsx"},
+		{Name: "r", Schedule: "every day"},
+		{Name: "r", Webhook: "ftp://example.com"},
+		{Name: "r", Email: []string{"not an address"}},
+		{Name: "r", Email: []string{"ops@example.com"}},
+	} {
+		_, err := rs.Put(r)
+		assert.ErrorIs(t, err, ErrInvalidReport, "%+v", r)
+	}
+	_, err = rs.Get("r")
+	assert.ErrorIs(t, err, ErrReportNotFound)
+}
+
+func TestReportRender(t *testing.T) {
+	c := NewCollector(Options{})
+	now := time.Now()
+	hour := now.Truncate(time.Hour)
+	c.Record(Access{Time: hour, Op: OpWrite, Key: "a.txt", Bytes: 10})
+	c.Record(Access{Time: hour, Op: OpRead, Key: "a.txt", Bytes: 10})
+	c.Record(Access{Time: hour, Op: OpRead, Key: "b.txt", Bytes: 5})
+	c.Record(Access{Time: now.Add(-48 * time.Hour), Op: OpRead, Key: "old.txt"})
+
+	r := Report{Name: "top", Grouping: GroupByTotal, Metrics: []Metric{MetricReads, MetricTotal}}
+	_, err := r.normalize()
+	require.NoError(t, err)
+	data, err := Render(c, r, now)
+	require.NoError(t, err)
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"key", "reads", "total"}, {"a.txt", "1", "2"}, {"b.txt", "1", "1"}}, records, "old.txt is out of range")
+
+	r = Report{Name: "hourly", Format: FormatJSON, Metrics: []Metric{MetricBytesRead}}
+	_, err = r.normalize()
+	require.NoError(t, err)
+	data, err = Render(c, r, now)
+	require.NoError(t, err)
+	var doc struct {
+		Rows []map[string]any `json:"rows"`
+	}
+	require.NoError(t, json.Unmarshal(data, &doc))
+	require.Len(t, doc.Rows, 2)
+	assert.Equal(t, map[string]any{"start": hour.UTC().Format(time.RFC3339), "key": "a.txt", "bytes_read": float64(10)}, doc.Rows[0])
+
+	r = Report{Name: "printed", Format: FormatPDF}
+	_, err = r.normalize()
+	require.NoError(t, err)
+	data, err = Render(c, r, now)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(data, []byte("%PDF-")))
+	text, err := search.Extract(search.FormatPDF, bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Contains(t, text, "printed: accesses by key")
+	assert.Contains(t, text, "a.txt")
+	assert.Contains(t, text, "bytes_written")
+}
+
+func TestReportRunStoresAndDelivers(t *testing.T) {
+	var hook struct {
+		report, key, contentType string
+		body                     []byte
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hook.report, hook.key, hook.contentType = r.Header.Get("X-PeerVault-Report"), r.Header.Get("X-PeerVault-Key"), r.Header.Get("Content-Type")
+		hook.body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	c := NewCollector(Options{})
+	c.Record(Access{Op: OpRead, Key: "a.txt"})
+	path := filepath.Join(t.TempDir(), "reports.json")
+	rs := NewReports(c, ReportsOptions{Path: path})
+	at := time.Date(2026, 3, 1, 6, 0, 0, 0, time.UTC)
+	rs.now = func() time.Time { return at }
+	_, err := rs.Put(Report{Name: "daily", Schedule: "0 7 * * *", Webhook: srv.URL})
+	require.NoError(t, err)
+
+	stored := make(map[string][]byte)
+	store := func(ctx context.Context, key string, data []byte) error {
+		stored[key] = data
+		return nil
+	}
+	run, err := rs.Run(context.Background(), "daily", store)
+	require.NoError(t, err)
+	assert.Empty(t, run.Errors)
+	assert.Equal(t, "reports/daily/20260301T060000Z.csv", run.Key)
+	require.Contains(t, stored, run.Key)
+	assert.Equal(t, stored[run.Key], hook.body)
+	assert.Equal(t, "daily", hook.report)
+	assert.Equal(t, run.Key, hook.key)
+	assert.Equal(t, "text/csv", hook.contentType)
+
+	_, err = rs.Run(context.Background(), "daily", func(context.Context, string, []byte) error { return errors.New("disk full") })
+	assert.EqualError(t, err, "disk full")
+	info, err := rs.Get("daily")
+	require.NoError(t, err)
+	assert.Equal(t, "disk full", info.LastError)
+	assert.Equal(t, run.Key, info.LastKey)
+
+	// The schedule fires once per matching minute
+	assert.Empty(t, rs.Due(at.Add(59*time.Minute)))
+	assert.Equal(t, []string{"daily"}, rs.Due(at.Add(time.Hour)))
+	assert.Empty(t, rs.Due(at.Add(time.Hour+time.Minute)))
+
+	reloaded := NewReports(c, ReportsOptions{Path: path})
+	require.NoError(t, reloaded.Load())
+	info, err = reloaded.Get("daily")
+	require.NoError(t, err)
+	assert.Equal(t, at.Add(25*time.Hour), info.NextRun)
+	assert.Equal(t, srv.URL, info.Webhook)
+	require.NoError(t, reloaded.Delete("daily"))
+	assert.Empty(t, reloaded.List())
+}
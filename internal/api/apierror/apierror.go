@@ -0,0 +1,243 @@
+// Package apierror is the error model every PeerVault API reports failures
+// in: a code clients can branch on, a message for people, optional details
+// and the ID of the request, which also appears in the node's logs and
+// audit entries. REST writes it as a JSON body, GraphQL as error
+// extensions and gRPC as a status with an ErrorInfo detail.
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Skpow1234/Peervault/internal/requestid"
+)
+
+// Codes of errors
+const (
+	CodeInvalidArgument    = "invalid_argument"
+	CodeUnauthenticated    = "unauthenticated"
+	CodePermissionDenied   = "permission_denied"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeFailedPrecondition = "failed_precondition"
+	CodeTooLarge           = "too_large"
+	CodeRateLimited        = "rate_limited"
+	CodeUnavailable        = "unavailable"
+	CodeTimeout            = "timeout"
+	CodeNotImplemented     = "not_implemented"
+	CodeInternal           = "internal"
+)
+
+// Domain is the ErrorInfo domain of gRPC errors
+const Domain = "peervault.io"
+
+// Error is an API error
+type Error struct {
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	Details   map[string]any `json:"details,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// New returns an error of code for the request of ctx
+func New(ctx context.Context, code, message string) *Error {
+	return &Error{Code: code, Message: message, RequestID: requestid.FromContext(ctx)}
+}
+
+// statusCodes maps codes to HTTP statuses
+var statusCodes = map[string]int{
+	CodeInvalidArgument:    http.StatusBadRequest,
+	CodeUnauthenticated:    http.StatusUnauthorized,
+	CodePermissionDenied:   http.StatusForbidden,
+	CodeNotFound:           http.StatusNotFound,
+	CodeMethodNotAllowed:   http.StatusMethodNotAllowed,
+	CodeConflict:           http.StatusConflict,
+	CodeFailedPrecondition: http.StatusPreconditionFailed,
+	CodeTooLarge:           http.StatusRequestEntityTooLarge,
+	CodeRateLimited:        http.StatusTooManyRequests,
+	CodeUnavailable:        http.StatusServiceUnavailable,
+	CodeTimeout:            http.StatusGatewayTimeout,
+	CodeNotImplemented:     http.StatusNotImplemented,
+	CodeInternal:           http.StatusInternalServerError,
+}
+
+// grpcCodes maps codes to gRPC codes
+var grpcCodes = map[string]codes.Code{
+	CodeInvalidArgument:    codes.InvalidArgument,
+	CodeUnauthenticated:    codes.Unauthenticated,
+	CodePermissionDenied:   codes.PermissionDenied,
+	CodeNotFound:           codes.NotFound,
+	CodeMethodNotAllowed:   codes.Unimplemented,
+	CodeConflict:           codes.AlreadyExists,
+	CodeFailedPrecondition: codes.FailedPrecondition,
+	CodeTooLarge:           codes.ResourceExhausted,
+	CodeRateLimited:        codes.ResourceExhausted,
+	CodeUnavailable:        codes.Unavailable,
+	CodeTimeout:            codes.DeadlineExceeded,
+	CodeNotImplemented:     codes.Unimplemented,
+	CodeInternal:           codes.Internal,
+}
+
+// HTTPStatus returns the HTTP status of code; unknown codes are internal
+// errors
+func HTTPStatus(code string) int {
+	if s, ok := statusCodes[code]; ok {
+		return s
+	}
+	return http.StatusInternalServerError
+}
+
+// CodeForHTTPStatus returns the code of an HTTP error status
+func CodeForHTTPStatus(httpStatus int) string {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusRequestedRangeNotSatisfiable, http.StatusUnsupportedMediaType:
+		return CodeInvalidArgument
+	case http.StatusGone:
+		return CodeNotFound
+	case http.StatusPreconditionRequired:
+		return CodeFailedPrecondition
+	case http.StatusBadGateway:
+		return CodeUnavailable
+	}
+	for code, s := range statusCodes {
+		if s == httpStatus && code != CodeInternal {
+			return code
+		}
+	}
+	if httpStatus < http.StatusInternalServerError {
+		return CodeInvalidArgument
+	}
+	return CodeInternal
+}
+
+// CodeForGRPC returns the code of a gRPC code
+func CodeForGRPC(c codes.Code) string {
+	switch c {
+	case codes.ResourceExhausted:
+		return CodeRateLimited
+	case codes.Unimplemented:
+		return CodeNotImplemented
+	case codes.Canceled, codes.OutOfRange:
+		return CodeInvalidArgument
+	case codes.Aborted:
+		return CodeConflict
+	}
+	for code, g := range grpcCodes {
+		if g == c {
+			return code
+		}
+	}
+	return CodeInternal
+}
+
+// Write writes e as the JSON body of a response, with the status of its
+// code
+func Write(w http.ResponseWriter, e *Error) {
+	WriteStatus(w, HTTPStatus(e.Code), e)
+}
+
+// WriteStatus writes e as the JSON body of a response with httpStatus
+func WriteStatus(w http.ResponseWriter, httpStatus int, e *Error) {
+	if e.RequestID == "" {
+		e.RequestID = w.Header().Get(requestid.Header)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Length")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(httpStatus)
+	_ = json.NewEncoder(w).Encode(e)
+}
+
+// GRPCStatus returns e as a gRPC status carrying an ErrorInfo with the code
+// as reason and the request ID and details as metadata
+func (e *Error) GRPCStatus() *status.Status {
+	code, ok := grpcCodes[e.Code]
+	if !ok {
+		code = codes.Internal
+	}
+	st := status.New(code, e.Message)
+	info := &errdetails.ErrorInfo{Reason: e.Code, Domain: Domain, Metadata: map[string]string{}}
+	if e.RequestID != "" {
+		info.Metadata["request_id"] = e.RequestID
+	}
+	for k, v := range e.Details {
+		if s, ok := v.(string); ok {
+			info.Metadata[k] = s
+		} else if b, err := json.Marshal(v); err == nil {
+			info.Metadata[k] = string(b)
+		}
+	}
+	if detailed, err := st.WithDetails(info); err == nil {
+		return detailed
+	}
+	return st
+}
+
+// FromGRPC returns the error model of a gRPC error, recovering the code and
+// request ID from an ErrorInfo detail when there is one
+func FromGRPC(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	st := status.Convert(err)
+	e = &Error{Code: CodeForGRPC(st.Code()), Message: st.Message()}
+	for _, d := range st.Details() {
+		info, ok := d.(*errdetails.ErrorInfo)
+		if !ok || info.Domain != Domain {
+			continue
+		}
+		e.Code = info.Reason
+		for k, v := range info.Metadata {
+			if k == "request_id" {
+				e.RequestID = v
+				continue
+			}
+			if e.Details == nil {
+				e.Details = make(map[string]any)
+			}
+			e.Details[k] = v
+		}
+	}
+	return e
+}
+
+// AnnotateGRPC adds an ErrorInfo with the request ID to a gRPC error that
+// has none, keeping its code and message
+func AnnotateGRPC(err error, requestID string) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		if e.RequestID == "" {
+			e.RequestID = requestID
+		}
+		return e.GRPCStatus().Err()
+	}
+	st := status.Convert(err)
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.Domain == Domain {
+			return err
+		}
+	}
+	info := &errdetails.ErrorInfo{Reason: CodeForGRPC(st.Code()), Domain: Domain}
+	if requestID != "" {
+		info.Metadata = map[string]string{"request_id": requestID}
+	}
+	if detailed, detailErr := st.WithDetails(info); detailErr == nil {
+		return detailed.Err()
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Skpow1234/Peervault/internal/requestid"
+)
+
+func TestMiddlewareRewritesPlainTextErrors(t *testing.T) {
+	handler := requestid.Middleware(Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such file", http.StatusNotFound)
+	})))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(requestid.Header, "trace-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var e Error
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&e))
+	assert.Equal(t, Error{Code: CodeNotFound, Message: "no such file", RequestID: "trace-1"}, e)
+}
+
+func TestMiddlewarePassesOtherResponses(t *testing.T) {
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/json" {
+			Write(w, &Error{Code: CodeConflict, Message: "exists", Details: map[string]any{"key": "a"}})
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/json", nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"code":"conflict","message":"exists","details":{"key":"a"}}`, w.Body.String())
+}
+
+func TestCodeForHTTPStatus(t *testing.T) {
+	assert.Equal(t, CodeInvalidArgument, CodeForHTTPStatus(http.StatusBadRequest))
+	assert.Equal(t, CodeUnauthenticated, CodeForHTTPStatus(http.StatusUnauthorized))
+	assert.Equal(t, CodeRateLimited, CodeForHTTPStatus(http.StatusTooManyRequests))
+	assert.Equal(t, CodeInvalidArgument, CodeForHTTPStatus(http.StatusTeapot))
+	assert.Equal(t, CodeInternal, CodeForHTTPStatus(http.StatusInternalServerError))
+	assert.Equal(t, CodeUnavailable, CodeForHTTPStatus(http.StatusBadGateway))
+}
+
+func TestGRPCRoundTrip(t *testing.T) {
+	e := New(requestid.NewContext(context.Background(), "trace-2"), CodeNotFound, "no such file")
+	e.Details = map[string]any{"key": "a"}
+	err := e.GRPCStatus().Err()
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, e, FromGRPC(err))
+
+	// Annotating keeps the code of plain statuses and adds the request ID
+	err = AnnotateGRPC(status.Error(codes.Aborted, "try again"), "trace-3")
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.Equal(t, &Error{Code: CodeConflict, Message: "try again", RequestID: "trace-3"}, FromGRPC(err))
+	assert.Equal(t, err, AnnotateGRPC(err, "trace-4"))
+	assert.NoError(t, AnnotateGRPC(nil, "trace-5"))
+}
@@ -0,0 +1,90 @@
+package apierror
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// maxMessage bounds the plain text errors Middleware turns into messages
+const maxMessage = 4096
+
+// Middleware rewrites plain text error responses, the ones http.Error
+// writes, into the error model, so handlers keep reporting failures with
+// http.Error and clients still get JSON with a code and the request ID.
+// Error responses that are JSON already, and every other response, pass
+// through untouched.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &errorWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		ew.finish()
+	})
+}
+
+// errorWriter buffers the body of plain text error responses
+type errorWriter struct {
+	http.ResponseWriter
+	status    int
+	capturing bool
+	body      bytes.Buffer
+}
+
+func (w *errorWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	if status >= http.StatusBadRequest && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		w.capturing = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *errorWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.capturing {
+		if room := maxMessage - w.body.Len(); room > 0 {
+			w.body.Write(p[:min(len(p), room)])
+		}
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// finish writes the captured error in the error model
+func (w *errorWriter) finish() {
+	if !w.capturing {
+		return
+	}
+	message := strings.TrimSpace(w.body.String())
+	if message == "" {
+		message = http.StatusText(w.status)
+	}
+	WriteStatus(w.ResponseWriter, w.status, &Error{Code: CodeForHTTPStatus(w.status), Message: message})
+}
+
+// Flush flushes responses that are not being captured
+func (w *errorWriter) Flush() {
+	if w.capturing {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades through
+func (w *errorWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *errorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,73 @@
+package coap
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+
+	"github.com/Skpow1234/Peervault/internal/devices"
+)
+
+// registerESTResources lets devices fetch the device CA and enroll for
+// certificates with EST over CoAP (RFC 9148). Devices cannot use HTTP
+// Basic auth here, so their requests prove their secret with the
+// challengePassword of devices.NewCSR.
+func (s *Server) registerESTResources(ca *devices.CA) {
+	s.registerResource(devices.ESTPath+"/crts", &Resource{
+		Name:          "EST CA Certificates",
+		Description:   "Certificate of the device CA",
+		Content:       ca.Certificate().Raw,
+		ContentFormat: &[]CoAPContentFormat{ContentFormatPKIXCert}[0],
+	})
+	s.registerResource(devices.ESTPath+"/sen", &Resource{
+		Name:          "EST Simple Enroll",
+		Description:   "Issues a device certificate for a PKCS#10 request",
+		ContentFormat: &[]CoAPContentFormat{ContentFormatPKIXCert}[0],
+		PostHandler:   s.estEnroll(ca, false),
+	})
+	s.registerResource(devices.ESTPath+"/sren", &Resource{
+		Name:          "EST Simple Re-enroll",
+		Description:   "Renews the certificate of a device holding a valid one",
+		ContentFormat: &[]CoAPContentFormat{ContentFormatPKIXCert}[0],
+		PostHandler:   s.estEnroll(ca, true),
+	})
+}
+
+// estEnroll handles a DER PKCS#10 request, answering with the DER
+// certificate issued
+func (s *Server) estEnroll(ca *devices.CA, renew bool) RequestHandler {
+	return func(message *Message, client *Client) (*Message, error) {
+		if format := message.GetOption(ContentFormat); format != nil && decodeUint(format) != uint32(ContentFormatPKCS10) {
+			return s.createErrorResponse(message, UnsupportedContentFormat), nil
+		}
+		csr, err := x509.ParseCertificateRequest(message.Payload)
+		if err != nil {
+			return s.createErrorResponse(message, BadRequest), nil
+		}
+		addr := ""
+		if client.Address != nil {
+			addr = client.Address.String()
+		}
+		cert, err := ca.Enroll(context.Background(), devices.EnrollRequest{CSR: csr, Renew: renew, Addr: addr})
+		switch {
+		case errors.Is(err, devices.ErrInvalid):
+			return s.createErrorResponse(message, BadRequest), nil
+		case errors.Is(err, devices.ErrDenied):
+			return s.createErrorResponse(message, Forbidden), nil
+		case err != nil:
+			return nil, err
+		}
+		response := s.createResponse(message, byte(Changed), cert.Raw)
+		response.AddOption(ContentFormat, uint16(ContentFormatPKIXCert))
+		return response, nil
+	}
+}
+
+// decodeUint decodes the value of a uint option
+func decodeUint(value []byte) uint32 {
+	var n uint32
+	for _, b := range value {
+		n = n<<8 | uint32(b)
+	}
+	return n
+}
@@ -0,0 +1,71 @@
+package coap
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skpow1234/Peervault/internal/devices"
+)
+
+func TestESTEnroll(t *testing.T) {
+	ca, err := devices.New(devices.Options{})
+	require.NoError(t, err)
+	_, secret, err := ca.Register(context.Background(), "sensor-1", "", "", "admin")
+	require.NoError(t, err)
+
+	server := NewServer(nil, &ServerConfig{MaxMessageSize: 1024, MaxAge: 60}, slog.Default())
+	defer server.Shutdown()
+	server.registerESTResources(ca)
+	client := &Client{ID: "test", Address: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5683}}
+
+	request := func(method MethodCode, path string, payload []byte) *Message {
+		t.Helper()
+		message := &Message{Type: Confirmable, Code: byte(method), MessageID: 1, Payload: payload}
+		message.AddOption(UriPath, ".well-known")
+		message.AddOption(UriPath, "est")
+		message.AddOption(UriPath, path)
+		if payload != nil {
+			message.AddOption(ContentFormat, uint16(ContentFormatPKCS10))
+		}
+		response, err := server.handleRequest(message, client)
+		require.NoError(t, err)
+		return response
+	}
+
+	response := request(GET, "crts", nil)
+	require.Equal(t, byte(Content), response.Code)
+	assert.Equal(t, ca.Certificate().Raw, response.Payload)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	csr, err := devices.NewCSR(key, "sensor-1", secret)
+	require.NoError(t, err)
+
+	response = request(POST, "sen", csr)
+	require.Equal(t, byte(Changed), response.Code)
+	cert, err := x509.ParseCertificate(response.Payload)
+	require.NoError(t, err)
+	id, err := ca.Verify(cert)
+	require.NoError(t, err)
+	assert.Equal(t, "sensor-1", id)
+
+	response = request(POST, "sren", csr)
+	assert.Equal(t, byte(Changed), response.Code)
+
+	csr, err = devices.NewCSR(key, "sensor-1", "wrong")
+	require.NoError(t, err)
+	response = request(POST, "sen", csr)
+	assert.Equal(t, byte(Forbidden), response.Code)
+
+	response = request(POST, "sen", []byte("not a request"))
+	assert.Equal(t, byte(BadRequest), response.Code)
+}
@@ -0,0 +1,129 @@
+package coap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/bits"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/firmware"
+)
+
+// defaultBlockSize is the largest block the server sends when the config
+// sets none
+const defaultBlockSize = 512
+
+// handleFirmware serves the firmware distribution under firmware.CoAPPath:
+// GET manifest?ep=&v= returns the update offered to a device running a
+// version, GET images/{id} an image block-wise (RFC 7959) and POST
+// status?ep= takes the JSON firmware.StatusReport of a device
+func (s *Server) handleFirmware(message *Message) (*Message, error) {
+	path := strings.TrimPrefix(message.GetPath(), firmware.CoAPPath+"/")
+	query := queries(message)
+	switch {
+	case path == "manifest" && MethodCode(message.Code) == GET:
+		manifest, err := s.firmware.Check(context.Background(), query["ep"], query["v"])
+		if err != nil {
+			return s.firmwareError(message, err)
+		}
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			return nil, err
+		}
+		return s.blockResponse(message, data, ContentFormatApplicationJSON), nil
+	case strings.HasPrefix(path, "images/") && MethodCode(message.Code) == GET:
+		data, err := s.firmware.Content(context.Background(), strings.TrimPrefix(path, "images/"))
+		if err != nil {
+			return s.firmwareError(message, err)
+		}
+		return s.blockResponse(message, data, ContentFormatApplicationOctetStream), nil
+	case path == "status" && MethodCode(message.Code) == POST:
+		var report firmware.StatusReport
+		if err := json.Unmarshal(message.Payload, &report); err != nil {
+			return s.createErrorResponse(message, BadRequest), nil
+		}
+		if _, err := s.firmware.Report(context.Background(), query["ep"], report); err != nil {
+			return s.firmwareError(message, err)
+		}
+		return s.createResponse(message, byte(Changed), nil), nil
+	case path == "manifest" || path == "status" || strings.HasPrefix(path, "images/"):
+		return s.createErrorResponse(message, MethodNotAllowed), nil
+	default:
+		return s.createErrorResponse(message, NotFound), nil
+	}
+}
+
+// firmwareError maps the errors of the firmware distribution to responses
+func (s *Server) firmwareError(message *Message, err error) (*Message, error) {
+	switch {
+	case errors.Is(err, firmware.ErrInvalid):
+		return s.createErrorResponse(message, BadRequest), nil
+	case errors.Is(err, firmware.ErrDenied):
+		return s.createErrorResponse(message, Forbidden), nil
+	case errors.Is(err, firmware.ErrNotFound), errors.Is(err, firmware.ErrNoUpdate):
+		return s.createErrorResponse(message, NotFound), nil
+	default:
+		return nil, err
+	}
+}
+
+// blockResponse answers with data, or the block of it the Block2 option
+// of the request asks for when data does not fit one block. The server
+// sends blocks of up to the configured block size; requests may ask for
+// smaller ones.
+func (s *Server) blockResponse(message *Message, data []byte, format CoAPContentFormat) *Message {
+	szx := blockSZX(s.config.BlockSize)
+	num := uint32(0)
+	if value := message.GetOption(Block2); value != nil {
+		block := decodeUint(value)
+		if block&0x7 == 7 {
+			return s.createErrorResponse(message, BadOption)
+		}
+		num, szx = block>>4, min(szx, block&0x7)
+	}
+	size := 16 << szx
+	if num == 0 && len(data) <= size {
+		response := s.createResponse(message, byte(Content), data)
+		response.AddOption(ContentFormat, uint16(format))
+		return response
+	}
+	start := int(num) * size
+	if start >= len(data) {
+		return s.createErrorResponse(message, BadOption)
+	}
+	end := min(start+size, len(data))
+	more := uint32(0)
+	if end < len(data) {
+		more = 1
+	}
+	response := s.createResponse(message, byte(Content), data[start:end])
+	response.AddOption(ContentFormat, uint16(format))
+	response.AddOption(Block2, num<<4|more<<3|szx)
+	if num == 0 {
+		response.AddOption(Size2, uint32(len(data)))
+	}
+	return response
+}
+
+// blockSZX returns the block size exponent of the largest block of at
+// most size bytes, between 16 (0) and 1024 (6)
+func blockSZX(size int) uint32 {
+	if size <= 0 {
+		size = defaultBlockSize
+	}
+	szx := bits.Len(uint(size)) - 5
+	return uint32(max(0, min(szx, 6)))
+}
+
+// queries returns the query parameters of a message
+func queries(message *Message) map[string]string {
+	values := make(map[string]string)
+	for _, option := range message.Options {
+		if option.Number == UriQuery {
+			key, value, _ := strings.Cut(string(option.Value), "=")
+			values[key] = value
+		}
+	}
+	return values
+}
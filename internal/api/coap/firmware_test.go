@@ -0,0 +1,113 @@
+package coap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skpow1234/Peervault/internal/firmware"
+)
+
+// memStore keeps firmware images in memory
+type memStore map[string][]byte
+
+func (s memStore) Has(key string) bool { _, ok := s[key]; return ok }
+
+func (s memStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if data, ok := s[key]; ok {
+		return data, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+func (s memStore) Put(ctx context.Context, key string, data []byte) error {
+	s[key] = data
+	return nil
+}
+
+func (s memStore) Delete(ctx context.Context, key string) error {
+	delete(s, key)
+	return nil
+}
+
+func TestFirmware(t *testing.T) {
+	ctx := context.Background()
+	manager, err := firmware.New(firmware.Options{})
+	require.NoError(t, err)
+	manager.Attach(memStore{})
+	data := bytes.Repeat([]byte("0123456789"), 50)
+	img, err := manager.AddImage(ctx, "sensor", "2.0.0", data, "", "admin")
+	require.NoError(t, err)
+	_, err = manager.CreateRollout(ctx, firmware.RolloutRequest{ImageID: img.ID, Rings: []firmware.Ring{{Name: "canary", Devices: []string{"sensor-1"}}}}, "admin")
+	require.NoError(t, err)
+
+	server := NewServer(nil, &ServerConfig{MaxMessageSize: 1024, BlockSize: 256, MaxAge: 60}, slog.Default())
+	defer server.Shutdown()
+	server.firmware = manager
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go server.ServeUDP(ctx, conn) //nolint:errcheck
+	device := newTestDevice(t, conn.LocalAddr().(*net.UDPAddr))
+	messageID := uint16(0)
+	request := func(method MethodCode, path string, payload []byte, options ...Option) *Message {
+		messageID++
+		message := &Message{Type: Confirmable, Code: byte(method), MessageID: messageID, Token: []byte{byte(messageID)}, Options: options, Payload: payload}
+		for _, segment := range bytes.Split([]byte(path[1:]), []byte("/")) {
+			message.AddOption(UriPath, string(segment))
+		}
+		device.send(message)
+		return device.receive()
+	}
+	// fetch reads a resource block by block, in blocks of 64 bytes
+	fetch := func(path string, options ...Option) (*Message, []byte) {
+		var payload []byte
+		for num := uint32(0); ; num++ {
+			response := request(GET, path, nil, append(options, Option{Number: Block2, Value: encodeUint32(num<<4 | 2)})...)
+			payload = append(payload, response.Payload...)
+			if response.Code != byte(Content) || decodeUint(response.GetOption(Block2))&0x8 == 0 {
+				return response, payload
+			}
+		}
+	}
+
+	// The manifest
+	response, payload := fetch("/fw/manifest", Option{Number: UriQuery, Value: []byte("ep=sensor-1")}, Option{Number: UriQuery, Value: []byte("v=1.0.0")})
+	require.Equal(t, byte(Content), response.Code)
+	var manifest firmware.Manifest
+	require.NoError(t, json.Unmarshal(payload, &manifest))
+	assert.Equal(t, img, manifest.Image)
+	response = request(GET, "/fw/manifest", nil, Option{Number: UriQuery, Value: []byte("ep=sensor-2")})
+	assert.Equal(t, byte(NotFound), response.Code)
+
+	// The image, block-wise: the server sends its own block size, then the
+	// smaller blocks the device asks for
+	response = request(GET, manifest.CoAPPath, nil)
+	require.Equal(t, byte(Content), response.Code)
+	assert.Equal(t, uint32(0<<4|1<<3|4), decodeUint(response.GetOption(Block2)))
+	assert.Equal(t, uint32(len(data)), decodeUint(response.GetOption(Size2)))
+	assert.Equal(t, data[:256], response.Payload)
+	response, image := fetch(manifest.CoAPPath)
+	require.Equal(t, byte(Content), response.Code)
+	assert.Equal(t, data, image)
+	response = request(GET, manifest.CoAPPath, nil, Option{Number: Block2, Value: encodeUint32(100<<4 | 3)})
+	assert.Equal(t, byte(BadOption), response.Code)
+
+	// The status
+	response = request(POST, "/fw/status", []byte(`{"image_id":"`+img.ID+`","state":"installed"}`), Option{Number: UriQuery, Value: []byte("ep=sensor-1")})
+	assert.Equal(t, byte(Changed), response.Code)
+	status, err := manager.Status("sensor-1")
+	require.NoError(t, err)
+	assert.Equal(t, firmware.StateInstalled, status.State)
+	response = request(POST, "/fw/status", []byte(`{"image_id":"`+img.ID+`","state":"done"}`), Option{Number: UriQuery, Value: []byte("ep=sensor-1")})
+	assert.Equal(t, byte(BadRequest), response.Code)
+}
@@ -0,0 +1,309 @@
+package coap
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/lwm2m"
+)
+
+// lwm2mRegistrationPath is where devices register (LwM2M registration
+// interface); their registrations are under it by ID
+const lwm2mRegistrationPath = "/rd"
+
+// Retransmission of confirmable requests (RFC 7252 section 4.8)
+var (
+	ackTimeout    = 2 * time.Second
+	maxRetransmit = 4
+)
+
+// exchange is a request the server sent to a device, waiting for its
+// response and, for observations, the notifications after it
+type exchange struct {
+	addr      string
+	messageID uint16
+	acked     chan struct{}
+	reset     chan struct{}
+	response  chan *Message
+	notify    func(lwm2m.Response)
+
+	once      sync.Once
+	responded bool
+}
+
+// registerLwM2MResources serves the LwM2M registration interface and lets
+// server reach the devices registered through it
+func (s *Server) registerLwM2MResources(server *lwm2m.Server) {
+	s.lwm2m = server
+	s.registerResource(lwm2mRegistrationPath, &Resource{
+		Name:          "LwM2M Registration",
+		Description:   "Registers LwM2M devices",
+		ContentFormat: &[]CoAPContentFormat{ContentFormatApplicationLinkFormat}[0],
+		PostHandler:   s.lwm2mRegister,
+	})
+	server.Attach(s)
+}
+
+// lwm2mRegister handles the registration of a device
+func (s *Server) lwm2mRegister(message *Message, client *Client) (*Message, error) {
+	req, err := registerRequest(message, client)
+	if err != nil {
+		return s.createErrorResponse(message, BadRequest), nil
+	}
+	reg, err := s.lwm2m.Register(context.Background(), req)
+	if err != nil {
+		return s.lwm2mError(message, err)
+	}
+	response := s.createResponse(message, byte(Created), nil)
+	response.AddOption(LocationPath, strings.Trim(lwm2mRegistrationPath, "/"))
+	response.AddOption(LocationPath, reg.ID)
+	return response, nil
+}
+
+// handleLwM2MRegistration handles the update (POST) and the
+// de-registration (DELETE) of the registration a path under /rd names
+func (s *Server) handleLwM2MRegistration(message *Message, client *Client) (*Message, error) {
+	id := strings.TrimPrefix(message.GetPath(), lwm2mRegistrationPath+"/")
+	switch MethodCode(message.Code) {
+	case POST:
+		req, err := registerRequest(message, client)
+		if err != nil {
+			return s.createErrorResponse(message, BadRequest), nil
+		}
+		if _, err := s.lwm2m.Update(context.Background(), id, req); err != nil {
+			return s.lwm2mError(message, err)
+		}
+		return s.createResponse(message, byte(Changed), nil), nil
+	case DELETE:
+		if err := s.lwm2m.Deregister(context.Background(), id); err != nil {
+			return s.lwm2mError(message, err)
+		}
+		return s.createResponse(message, byte(Deleted), nil), nil
+	default:
+		return s.createErrorResponse(message, MethodNotAllowed), nil
+	}
+}
+
+// lwm2mError maps the errors of the registration interface to responses
+func (s *Server) lwm2mError(message *Message, err error) (*Message, error) {
+	switch {
+	case errors.Is(err, lwm2m.ErrInvalid):
+		return s.createErrorResponse(message, BadRequest), nil
+	case errors.Is(err, lwm2m.ErrDenied):
+		return s.createErrorResponse(message, Forbidden), nil
+	case errors.Is(err, lwm2m.ErrNotFound):
+		return s.createErrorResponse(message, NotFound), nil
+	default:
+		return nil, err
+	}
+}
+
+// registerRequest reads the query parameters and the links of a
+// registration or registration update
+func registerRequest(message *Message, client *Client) (lwm2m.RegisterRequest, error) {
+	req := lwm2m.RegisterRequest{Links: string(message.Payload)}
+	if client.Address != nil {
+		req.Addr = client.Address.String()
+	}
+	for _, option := range message.Options {
+		if option.Number != UriQuery {
+			continue
+		}
+		key, value, _ := strings.Cut(string(option.Value), "=")
+		switch key {
+		case "ep":
+			req.Endpoint = value
+		case "lt":
+			lifetime, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return req, fmt.Errorf("invalid lifetime %q", value)
+			}
+			req.Lifetime = time.Duration(lifetime) * time.Second
+		case "lwm2m":
+			req.Version = value
+		case "b":
+			req.Binding = value
+		}
+	}
+	return req, nil
+}
+
+// Send sends a confirmable request to a device and waits for its
+// response, as lwm2m.Transport
+func (s *Server) Send(ctx context.Context, addr string, req lwm2m.Request) (lwm2m.Response, error) {
+	resp, cancel, err := s.exchange(ctx, addr, req, nil)
+	if err != nil {
+		return lwm2m.Response{}, err
+	}
+	cancel()
+	return resp, nil
+}
+
+// Observe sends a request with the Observe option, passing the
+// notifications after the response to notify, as lwm2m.Transport
+func (s *Server) Observe(ctx context.Context, addr string, req lwm2m.Request, notify func(lwm2m.Response)) (lwm2m.Response, func(), error) {
+	return s.exchange(ctx, addr, req, notify)
+}
+
+// exchange sends a request, retransmitting it until the device
+// acknowledges it, and returns the response and a function forgetting
+// the exchange. Devices whose notifications arrive after that get a reset,
+// which ends their observation.
+func (s *Server) exchange(ctx context.Context, addr string, req lwm2m.Request, notify func(lwm2m.Response)) (lwm2m.Response, func(), error) {
+	conn := s.conn.Load()
+	if conn == nil {
+		return lwm2m.Response{}, nil, errors.New("CoAP server is not serving")
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return lwm2m.Response{}, nil, err
+	}
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return lwm2m.Response{}, nil, err
+	}
+
+	message := &Message{Type: Confirmable, Code: req.Method, MessageID: uint16(s.messageID.Add(1)), Token: token, Payload: req.Payload}
+	if notify != nil {
+		message.AddOption(Observe, uint32(0))
+	}
+	for _, segment := range strings.Split(strings.Trim(req.Path, "/"), "/") {
+		message.AddOption(UriPath, segment)
+	}
+	if req.ContentFormat >= 0 {
+		message.AddOption(ContentFormat, uint16(req.ContentFormat))
+	}
+	data, err := message.Encode()
+	if err != nil {
+		return lwm2m.Response{}, nil, err
+	}
+
+	ex := &exchange{
+		addr:      udpAddr.String(),
+		messageID: message.MessageID,
+		acked:     make(chan struct{}),
+		reset:     make(chan struct{}),
+		response:  make(chan *Message, 1),
+		notify:    notify,
+	}
+	key := string(token)
+	s.exchangesMu.Lock()
+	s.exchanges[key] = ex
+	s.exchangesMu.Unlock()
+	cancel := func() {
+		s.exchangesMu.Lock()
+		defer s.exchangesMu.Unlock()
+		delete(s.exchanges, key)
+	}
+
+	if _, err := conn.WriteToUDP(data, udpAddr); err != nil {
+		cancel()
+		return lwm2m.Response{}, nil, err
+	}
+	timeout := ackTimeout
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	acked, retransmit := ex.acked, timer.C
+	for attempts := 0; ; {
+		select {
+		case response := <-ex.response:
+			return lwm2m.Response{Code: response.Code, ContentFormat: contentFormat(response), Payload: response.Payload}, cancel, nil
+		case <-acked:
+			// The response follows separately; stop retransmitting
+			acked, retransmit = nil, nil
+		case <-ex.reset:
+			cancel()
+			return lwm2m.Response{}, nil, fmt.Errorf("%s reset the request", addr)
+		case <-retransmit:
+			if attempts++; attempts > maxRetransmit {
+				cancel()
+				return lwm2m.Response{}, nil, fmt.Errorf("%s did not acknowledge the request", addr)
+			}
+			if _, err := conn.WriteToUDP(data, udpAddr); err != nil {
+				cancel()
+				return lwm2m.Response{}, nil, err
+			}
+			timeout *= 2
+			timer.Reset(timeout)
+		case <-ctx.Done():
+			cancel()
+			return lwm2m.Response{}, nil, ctx.Err()
+		}
+	}
+}
+
+// handleExchangeMessage routes acknowledgements, resets and responses to
+// the exchanges they answer, reporting whether message was one
+func (s *Server) handleExchangeMessage(conn *net.UDPConn, clientAddr *net.UDPAddr, message *Message) bool {
+	addr := clientAddr.String()
+	switch {
+	case message.Code == 0 && (message.Type == Acknowledgement || message.Type == Reset):
+		// Empty acknowledgements and resets name the message they answer
+		s.exchangesMu.Lock()
+		for _, ex := range s.exchanges {
+			if ex.addr == addr && ex.messageID == message.MessageID {
+				if message.Type == Acknowledgement {
+					ex.once.Do(func() { close(ex.acked) })
+				} else {
+					ex.once.Do(func() { close(ex.reset) })
+				}
+			}
+		}
+		s.exchangesMu.Unlock()
+		return true
+	case message.Code>>5 == 0:
+		// Requests
+		return false
+	}
+
+	// Responses and notifications name the request they answer by token
+	s.exchangesMu.Lock()
+	ex := s.exchanges[string(message.Token)]
+	if ex != nil && ex.addr != addr {
+		ex = nil
+	}
+	first := ex != nil && !ex.responded
+	if first {
+		ex.responded = true
+	}
+	s.exchangesMu.Unlock()
+
+	switch {
+	case ex == nil:
+		if message.Type != Acknowledgement {
+			s.sendEmpty(conn, clientAddr, Reset, message.MessageID)
+		}
+		return true
+	case message.Type == Confirmable:
+		s.sendEmpty(conn, clientAddr, Acknowledgement, message.MessageID)
+	}
+	if first {
+		ex.response <- message
+	} else if ex.notify != nil {
+		ex.notify(lwm2m.Response{Code: message.Code, ContentFormat: contentFormat(message), Payload: message.Payload})
+	}
+	return true
+}
+
+// sendEmpty sends an empty acknowledgement or reset
+func (s *Server) sendEmpty(conn *net.UDPConn, addr *net.UDPAddr, typ MessageType, messageID uint16) {
+	if err := s.sendResponse(conn, addr, &Message{Type: typ, MessageID: messageID}); err != nil {
+		s.logger.Error("Failed to send CoAP message", "error", err, "client", addr)
+	}
+}
+
+// contentFormat returns the content format of a message, or -1 for none
+func contentFormat(message *Message) int {
+	value := message.GetOption(ContentFormat)
+	if value == nil {
+		return -1
+	}
+	return int(decodeUint(value))
+}
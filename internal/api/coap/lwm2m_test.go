@@ -0,0 +1,206 @@
+package coap
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skpow1234/Peervault/internal/lwm2m"
+)
+
+// testDevice is an LwM2M device talking to the server over UDP
+type testDevice struct {
+	t        *testing.T
+	conn     *net.UDPConn
+	server   *net.UDPAddr
+	incoming chan *Message
+}
+
+func newTestDevice(t *testing.T, server *net.UDPAddr) *testDevice {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	d := &testDevice{t: t, conn: conn, server: server, incoming: make(chan *Message, 16)}
+	go func() {
+		buffer := make([]byte, 2048)
+		for {
+			n, _, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			if message, err := ParseMessage(append([]byte(nil), buffer[:n]...)); err == nil {
+				d.incoming <- message
+			}
+		}
+	}()
+	return d
+}
+
+func (d *testDevice) send(message *Message) {
+	d.t.Helper()
+	data, err := message.Encode()
+	require.NoError(d.t, err)
+	_, err = d.conn.WriteToUDP(data, d.server)
+	require.NoError(d.t, err)
+}
+
+func (d *testDevice) receive() *Message {
+	d.t.Helper()
+	select {
+	case message := <-d.incoming:
+		return message
+	case <-time.After(5 * time.Second):
+		d.t.Fatal("no message from the server")
+		return nil
+	}
+}
+
+// reply answers a request in its acknowledgement
+func (d *testDevice) reply(request *Message, code ResponseCode, payload string, options ...Option) {
+	d.t.Helper()
+	d.send(&Message{Type: Acknowledgement, Code: byte(code), MessageID: request.MessageID, Token: request.Token, Options: options, Payload: []byte(payload)})
+}
+
+func TestLwM2M(t *testing.T) {
+	lw := lwm2m.New(lwm2m.Options{})
+	server := NewServer(nil, &ServerConfig{MaxMessageSize: 1024, MaxAge: 60}, slog.Default())
+	defer server.Shutdown()
+	server.registerLwM2MResources(lw)
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.ServeUDP(ctx, conn) //nolint:errcheck
+	require.Eventually(t, func() bool { return server.conn.Load() != nil }, time.Second, time.Millisecond)
+	device := newTestDevice(t, conn.LocalAddr().(*net.UDPAddr))
+
+	// Register
+	register := &Message{Type: Confirmable, Code: byte(POST), MessageID: 1, Token: []byte{1}, Payload: []byte("</1/0>,</3/0>")}
+	register.AddOption(UriPath, "rd")
+	register.AddOption(UriQuery, "ep=sensor-1")
+	register.AddOption(UriQuery, "lt=300")
+	register.AddOption(UriQuery, "lwm2m=1.1")
+	register.AddOption(UriQuery, "b=U")
+	device.send(register)
+	response := device.receive()
+	require.Equal(t, byte(Created), response.Code)
+	var location []string
+	for _, option := range response.Options {
+		if option.Number == LocationPath {
+			location = append(location, string(option.Value))
+		}
+	}
+	require.Len(t, location, 2)
+	assert.Equal(t, "rd", location[0])
+	reg, err := lw.Client("sensor-1")
+	require.NoError(t, err)
+	assert.Equal(t, location[1], reg.ID)
+	assert.Equal(t, device.conn.LocalAddr().String(), reg.Addr)
+	assert.Equal(t, int64(300), reg.Lifetime)
+	assert.Equal(t, []string{"/1/0", "/3/0"}, reg.Objects)
+
+	type result struct {
+		values []lwm2m.Value
+		err    error
+	}
+	results := make(chan result, 1)
+
+	// Read, answered in the acknowledgement
+	go func() {
+		values, err := lw.Read(ctx, "sensor-1", "/3/0/9")
+		results <- result{values, err}
+	}()
+	request := device.receive()
+	assert.Equal(t, Confirmable, request.Type)
+	assert.Equal(t, byte(GET), request.Code)
+	assert.Equal(t, "/3/0/9", request.GetPath())
+	device.reply(request, Content, "85", Option{Number: ContentFormat, Value: []byte{}})
+	r := <-results
+	require.NoError(t, r.err)
+	assert.Equal(t, []lwm2m.Value{{Path: "/3/0/9", Value: int64(85)}}, r.values)
+
+	// Write, acknowledged first and answered separately
+	go func() { results <- result{err: lw.Write(ctx, "sensor-1", "/1/0/1", float64(600), "admin")} }()
+	request = device.receive()
+	assert.Equal(t, byte(PUT), request.Code)
+	assert.Equal(t, "/1/0/1", request.GetPath())
+	assert.Equal(t, "600", string(request.Payload))
+	device.send(&Message{Type: Acknowledgement, MessageID: request.MessageID})
+	device.send(&Message{Type: Confirmable, Code: byte(Changed), MessageID: 100, Token: request.Token})
+	ack := device.receive()
+	assert.Equal(t, Acknowledgement, ack.Type)
+	assert.Equal(t, uint16(100), ack.MessageID)
+	require.NoError(t, (<-results).err)
+
+	// Observe
+	go func() {
+		values, err := lw.Observe(ctx, "sensor-1", "/3/0/9")
+		results <- result{values, err}
+	}()
+	request = device.receive()
+	assert.True(t, request.HasOption(Observe))
+	device.reply(request, Content, "85", Option{Number: Observe, Value: []byte{1}})
+	r = <-results
+	require.NoError(t, r.err)
+	assert.Equal(t, []lwm2m.Value{{Path: "/3/0/9", Value: int64(85)}}, r.values)
+
+	device.send(&Message{Type: Confirmable, Code: byte(Content), MessageID: 101, Token: request.Token, Options: []Option{{Number: Observe, Value: []byte{2}}}, Payload: []byte("80")})
+	ack = device.receive()
+	assert.Equal(t, Acknowledgement, ack.Type)
+	assert.Equal(t, uint16(101), ack.MessageID)
+	require.Eventually(t, func() bool {
+		reg, err := lw.Client("sensor-1")
+		return err == nil && len(reg.Observations["/3/0/9"]) == 1 && reg.Observations["/3/0/9"][0].Value == int64(80)
+	}, time.Second, 10*time.Millisecond)
+
+	// Notifications after cancelling are reset, which ends the observation
+	require.NoError(t, lw.CancelObservation("sensor-1", "/3/0/9"))
+	device.send(&Message{Type: NonConfirmable, Code: byte(Content), MessageID: 102, Token: request.Token, Options: []Option{{Number: Observe, Value: []byte{3}}}, Payload: []byte("75")})
+	reset := device.receive()
+	assert.Equal(t, Reset, reset.Type)
+	assert.Equal(t, uint16(102), reset.MessageID)
+
+	// Requests are retransmitted until acknowledged
+	defer func(timeout time.Duration) { ackTimeout = timeout }(ackTimeout)
+	ackTimeout = 50 * time.Millisecond
+	go func() { results <- result{err: lw.Execute(ctx, "sensor-1", "/3/0/4", "", "admin")} }()
+	request = device.receive()
+	retransmitted := device.receive()
+	assert.Equal(t, request.MessageID, retransmitted.MessageID)
+	assert.Equal(t, "/3/0/4", retransmitted.GetPath())
+	device.reply(retransmitted, Changed, "")
+	require.NoError(t, (<-results).err)
+
+	// Update and de-register
+	update := &Message{Type: Confirmable, Code: byte(POST), MessageID: 2, Token: []byte{2}}
+	update.AddOption(UriPath, "rd")
+	update.AddOption(UriPath, reg.ID)
+	update.AddOption(UriQuery, "lt=600")
+	device.send(update)
+	assert.Equal(t, byte(Changed), device.receive().Code)
+	reg, err = lw.Client("sensor-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(600), reg.Lifetime)
+
+	deregister := &Message{Type: Confirmable, Code: byte(DELETE), MessageID: 3, Token: []byte{3}}
+	deregister.AddOption(UriPath, "rd")
+	deregister.AddOption(UriPath, reg.ID)
+	device.send(deregister)
+	assert.Equal(t, byte(Deleted), device.receive().Code)
+	deregister.MessageID = 4
+	device.send(deregister)
+	assert.Equal(t, byte(NotFound), device.receive().Code)
+
+	// Registrations need an endpoint name
+	register.Options = []Option{{Number: UriPath, Value: []byte("rd")}}
+	register.MessageID = 5
+	device.send(register)
+	assert.Equal(t, byte(BadRequest), device.receive().Code)
+}
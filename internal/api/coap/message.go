@@ -1,8 +1,11 @@
 package coap
 
 import (
+	"cmp"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"slices"
 )
 
 // MessageType represents the CoAP message type
@@ -79,6 +82,7 @@ const (
 	Observe       OptionNumber = 6
 	Block1        OptionNumber = 27
 	Block2        OptionNumber = 23
+	Size2         OptionNumber = 28
 )
 
 // CoAPContentFormat represents CoAP content formats
@@ -92,6 +96,10 @@ const (
 	ContentFormatApplicationEXI         CoAPContentFormat = 47
 	ContentFormatApplicationJSON        CoAPContentFormat = 50
 	ContentFormatApplicationCBOR        CoAPContentFormat = 60
+	// Content formats of EST over CoAP (RFC 9148)
+	ContentFormatPKCS7CertsOnly CoAPContentFormat = 281
+	ContentFormatPKCS10         CoAPContentFormat = 286
+	ContentFormatPKIXCert       CoAPContentFormat = 287
 )
 
 // Message represents a CoAP message
@@ -177,14 +185,29 @@ func (m *Message) Encode() ([]byte, error) {
 		return nil, fmt.Errorf("token too long: %d bytes", tokenLength)
 	}
 
+	// Options are encoded in order, each by its delta to the previous one
+	options := slices.Clone(m.Options)
+	slices.SortStableFunc(options, func(a, b Option) int { return cmp.Compare(a.Number, b.Number) })
+	for _, option := range options {
+		if len(option.Value) > maxOptionLength {
+			return nil, fmt.Errorf("option %d too long: %d bytes", option.Number, len(option.Value))
+		}
+	}
+
 	// Calculate options size
 	optionsSize := 0
-	for _, option := range m.Options {
-		optionsSize += m.encodeOptionSize(option)
+	prev := OptionNumber(0)
+	for _, option := range options {
+		optionsSize += m.encodeOptionSize(uint16(option.Number-prev), option)
+		prev = option.Number
 	}
 
 	// Calculate total size
-	totalSize := headerSize + tokenLength + optionsSize + len(m.Payload)
+	totalSize := headerSize + tokenLength + optionsSize
+	if len(m.Payload) > 0 {
+		// Payload marker and payload
+		totalSize += 1 + len(m.Payload)
+	}
 
 	// Create buffer
 	buffer := make([]byte, totalSize)
@@ -200,8 +223,10 @@ func (m *Message) Encode() ([]byte, error) {
 	}
 
 	// Encode options
-	for _, option := range m.Options {
-		offset = m.encodeOption(buffer, offset, option)
+	prev = 0
+	for _, option := range options {
+		offset = m.encodeOption(buffer, offset, uint16(option.Number-prev), option)
+		prev = option.Number
 	}
 
 	// Encode payload
@@ -238,105 +263,54 @@ func (m *Message) encodeHeader(buffer []byte, offset int, tokenLength int) int {
 }
 
 // encodeOptionSize calculates the size needed to encode an option
-func (m *Message) encodeOptionSize(option Option) int {
-	// Option delta and length are encoded in the first byte
-	// Additional bytes for delta and length if needed
-	size := 1
-
-	// Delta encoding
-	delta := uint16(option.Number)
-	if delta >= 13 {
-		if delta < 269 {
-			size++ // 1 additional byte
-		} else {
-			size += 2 // 2 additional bytes
-		}
-	}
-
-	// Length encoding
-	length := uint16(len(option.Value))
-	if length >= 13 {
-		if length < 269 {
-			size++ // 1 additional byte
-		} else {
-			size += 2 // 2 additional bytes
-		}
-	}
-
-	// Value
-	size += len(option.Value)
-
-	return size
+// following the option delta numbers before it
+func (m *Message) encodeOptionSize(delta uint16, option Option) int {
+	_, deltaExt := optionNibble(delta)
+	_, lengthExt := optionNibble(uint16(len(option.Value)))
+	// Option delta and length share the first byte
+	return 1 + len(deltaExt) + len(lengthExt) + len(option.Value)
 }
 
-// encodeOption encodes an option
-func (m *Message) encodeOption(buffer []byte, offset int, option Option) int {
-	delta := uint16(option.Number)
-	length := uint16(len(option.Value))
-
-	// Encode delta and length in first byte
-	firstByte := byte(0)
-
-	// Delta encoding
-	if delta < 13 {
-		firstByte |= byte(delta) << 4
-	} else if delta < 269 {
-		firstByte |= 13 << 4
-		delta -= 13
-	} else {
-		firstByte |= 14 << 4
-		delta -= 269
-	}
+// encodeOption encodes an option following the option delta numbers
+// before it
+func (m *Message) encodeOption(buffer []byte, offset int, delta uint16, option Option) int {
+	deltaNibble, deltaExt := optionNibble(delta)
+	lengthNibble, lengthExt := optionNibble(uint16(len(option.Value)))
 
-	// Length encoding
-	if length < 13 {
-		firstByte |= byte(length)
-	} else if length < 269 {
-		firstByte |= 13
-		length -= 13
-	} else {
-		firstByte |= 14
-		length -= 269
-	}
-
-	buffer[offset] = firstByte
+	buffer[offset] = deltaNibble<<4 | lengthNibble
 	offset++
+	offset += copy(buffer[offset:], deltaExt)
+	offset += copy(buffer[offset:], lengthExt)
+	offset += copy(buffer[offset:], option.Value)
 
-	// Encode additional delta bytes
-	if delta >= 13 {
-		if delta < 269 {
-			buffer[offset] = byte(delta)
-			offset++
-		} else {
-			binary.BigEndian.PutUint16(buffer[offset:], delta)
-			offset += 2
-		}
-	}
+	return offset
+}
 
-	// Encode additional length bytes
-	if length >= 13 {
-		if length < 269 {
-			buffer[offset] = byte(length)
-			offset++
-		} else {
-			binary.BigEndian.PutUint16(buffer[offset:], length)
-			offset += 2
-		}
+// optionNibble returns the 4-bit encoding of an option delta or length,
+// with the extended bytes values from 13 up need
+func optionNibble(v uint16) (byte, []byte) {
+	switch {
+	case v < 13:
+		return byte(v), nil
+	case v < 269:
+		return 13, []byte{byte(v - 13)}
+	default:
+		return 14, binary.BigEndian.AppendUint16(nil, v-269)
 	}
+}
 
-	// Encode value
-	if len(option.Value) > 0 {
-		copy(buffer[offset:], option.Value)
-		offset += len(option.Value)
-	}
+// ErrMessageFormat is wrapped by the errors of messages that break the
+// message format of RFC 7252, which are rejected rather than guessed at
+var ErrMessageFormat = errors.New("CoAP message format error")
 
-	return offset
-}
+// maxOptionLength is the longest option value Encode writes
+const maxOptionLength = 0xFFFF
 
-// ParseMessage parses a CoAP message from bytes
+// ParseMessage parses a CoAP message from bytes. The token, options and
+// payload of the message are copies, so data may be reused.
 func ParseMessage(data []byte) (*Message, error) {
 	if len(data) < 4 {
-		return nil, fmt.Errorf("message too short: %d bytes", len(data))
+		return nil, fmt.Errorf("%w: message too short: %d bytes", ErrMessageFormat, len(data))
 	}
 
 	message := &Message{}
@@ -345,33 +319,45 @@ func ParseMessage(data []byte) (*Message, error) {
 	header := data[0]
 	version := (header >> 6) & 0x03
 	if version != 1 {
-		return nil, fmt.Errorf("unsupported CoAP version: %d", version)
+		return nil, fmt.Errorf("%w: unsupported CoAP version: %d", ErrMessageFormat, version)
 	}
 
 	message.Type = MessageType((header >> 4) & 0x03)
-	tokenLength := header & 0x0F
+	tokenLength := int(header & 0x0F)
+	if tokenLength > 8 {
+		return nil, fmt.Errorf("%w: token length %d", ErrMessageFormat, tokenLength)
+	}
 
 	message.Code = data[1]
 	message.MessageID = binary.BigEndian.Uint16(data[2:4])
 
+	// An empty message is the header alone
+	if message.Code == 0 && (tokenLength > 0 || len(data) > 4) {
+		return nil, fmt.Errorf("%w: empty message with %d more bytes", ErrMessageFormat, len(data)-4)
+	}
+
 	offset := 4
 
 	// Parse token
 	if tokenLength > 0 {
-		if offset+int(tokenLength) > len(data) {
-			return nil, fmt.Errorf("token extends beyond message")
+		if offset+tokenLength > len(data) {
+			return nil, fmt.Errorf("%w: token extends beyond message", ErrMessageFormat)
 		}
 		message.Token = make([]byte, tokenLength)
-		copy(message.Token, data[offset:offset+int(tokenLength)])
-		offset += int(tokenLength)
+		copy(message.Token, data[offset:offset+tokenLength])
+		offset += tokenLength
 	}
 
 	// Parse options
-	prevOptionNumber := uint16(0)
+	prevOptionNumber := 0
 	for offset < len(data) {
 		if data[offset] == 0xFF {
-			// Payload marker
+			// Payload marker, which a payload must follow
 			offset++
+			if offset == len(data) {
+				return nil, fmt.Errorf("%w: payload marker without payload", ErrMessageFormat)
+			}
+			message.Payload = append([]byte(nil), data[offset:]...)
 			break
 		}
 
@@ -381,71 +367,45 @@ func ParseMessage(data []byte) (*Message, error) {
 		}
 
 		message.Options = append(message.Options, option)
-		prevOptionNumber = uint16(option.Number)
+		prevOptionNumber = int(option.Number)
 		offset = newOffset
 	}
 
-	// Parse payload
-	if offset < len(data) {
-		message.Payload = data[offset:]
-	}
-
 	return message, nil
 }
 
-// parseOption parses a CoAP option
-func parseOption(data []byte, offset int, prevOptionNumber uint16) (Option, int, error) {
+// parseOption parses a CoAP option following the option numbered
+// prevOptionNumber
+func parseOption(data []byte, offset int, prevOptionNumber int) (Option, int, error) {
 	if offset >= len(data) {
-		return Option{}, offset, fmt.Errorf("unexpected end of message")
+		return Option{}, offset, fmt.Errorf("%w: unexpected end of message", ErrMessageFormat)
 	}
 
 	firstByte := data[offset]
 	offset++
 
-	// Parse delta
-	delta := uint16((firstByte >> 4) & 0x0F)
-	switch delta {
-	case 13:
-		if offset >= len(data) {
-			return Option{}, offset, fmt.Errorf("unexpected end of message")
-		}
-		delta = uint16(data[offset]) + 13
-		offset++
-	case 14:
-		if offset+1 >= len(data) {
-			return Option{}, offset, fmt.Errorf("unexpected end of message")
-		}
-		delta = binary.BigEndian.Uint16(data[offset:]) + 269
-		offset += 2
+	delta, offset, err := parseOptionNibble(data, offset, firstByte>>4)
+	if err != nil {
+		return Option{}, offset, fmt.Errorf("option delta: %w", err)
+	}
+	length, offset, err := parseOptionNibble(data, offset, firstByte&0x0F)
+	if err != nil {
+		return Option{}, offset, fmt.Errorf("option length: %w", err)
 	}
 
-	// Parse length
-	length := uint16(firstByte & 0x0F)
-	switch length {
-	case 13:
-		if offset >= len(data) {
-			return Option{}, offset, fmt.Errorf("unexpected end of message")
-		}
-		length = uint16(data[offset]) + 13
-		offset++
-	case 14:
-		if offset+1 >= len(data) {
-			return Option{}, offset, fmt.Errorf("unexpected end of message")
-		}
-		length = binary.BigEndian.Uint16(data[offset:]) + 269
-		offset += 2
+	optionNumber := prevOptionNumber + delta
+	if optionNumber > 0xFFFF {
+		return Option{}, offset, fmt.Errorf("%w: option number %d out of range", ErrMessageFormat, optionNumber)
 	}
 
 	// Parse value
-	if offset+int(length) > len(data) {
-		return Option{}, offset, fmt.Errorf("option value extends beyond message")
+	if offset+length > len(data) {
+		return Option{}, offset, fmt.Errorf("%w: option value extends beyond message", ErrMessageFormat)
 	}
 
 	value := make([]byte, length)
-	copy(value, data[offset:offset+int(length)])
-	offset += int(length)
-
-	optionNumber := prevOptionNumber + delta
+	copy(value, data[offset:offset+length])
+	offset += length
 
 	return Option{
 		Number: OptionNumber(optionNumber),
@@ -453,6 +413,28 @@ func parseOption(data []byte, offset int, prevOptionNumber uint16) (Option, int,
 	}, offset, nil
 }
 
+// parseOptionNibble reads an option delta or length from its 4-bit
+// encoding and the extended bytes following at offset
+func parseOptionNibble(data []byte, offset int, nibble byte) (int, int, error) {
+	switch nibble {
+	case 13:
+		if offset >= len(data) {
+			return 0, offset, fmt.Errorf("%w: unexpected end of message", ErrMessageFormat)
+		}
+		return int(data[offset]) + 13, offset + 1, nil
+	case 14:
+		if offset+2 > len(data) {
+			return 0, offset, fmt.Errorf("%w: unexpected end of message", ErrMessageFormat)
+		}
+		return int(binary.BigEndian.Uint16(data[offset:])) + 269, offset + 2, nil
+	case 15:
+		// Reserved for the payload marker
+		return 0, offset, fmt.Errorf("%w: reserved nibble 15", ErrMessageFormat)
+	default:
+		return int(nibble), offset, nil
+	}
+}
+
 // Helper functions for encoding values
 
 // encodeUint32 encodes a uint32 to bytes
@@ -0,0 +1,81 @@
+package coap
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	message := &Message{Type: Confirmable, Code: byte(POST), MessageID: 0x1234, Token: []byte{1, 2, 3, 4}, Payload: []byte("</1/0>,</3/0>")}
+	// Added out of order, with deltas and lengths needing extended bytes
+	message.AddOption(Size1, uint32(70000))
+	message.AddOption(UriQuery, "ep="+strings.Repeat("x", 20))
+	message.AddOption(UriPath, "rd")
+	message.AddOption(ContentFormat, uint16(ContentFormatApplicationLinkFormat))
+	message.AddOption(UriQuery, "lt="+strings.Repeat("9", 300))
+	message.AddOption(Observe, uint32(0))
+
+	data, err := message.Encode()
+	require.NoError(t, err)
+	parsed, err := ParseMessage(data)
+	require.NoError(t, err)
+
+	assert.Equal(t, message.Type, parsed.Type)
+	assert.Equal(t, message.Code, parsed.Code)
+	assert.Equal(t, message.MessageID, parsed.MessageID)
+	assert.Equal(t, message.Token, parsed.Token)
+	assert.Equal(t, message.Payload, parsed.Payload)
+	assert.Equal(t, []Option{
+		{Number: Observe, Value: []byte{}},
+		{Number: UriPath, Value: []byte("rd")},
+		{Number: ContentFormat, Value: []byte{40}},
+		{Number: UriQuery, Value: []byte("ep=" + strings.Repeat("x", 20))},
+		{Number: UriQuery, Value: []byte("lt=" + strings.Repeat("9", 300))},
+		{Number: Size1, Value: []byte{0x01, 0x11, 0x70}},
+	}, parsed.Options)
+	assert.Equal(t, "/rd", parsed.GetPath())
+}
+
+func TestParseMessageRejectsFormatErrors(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"short":                     {0x40, 0x01},
+		"version 2":                 {0x80, 0x01, 0x00, 0x01},
+		"token length 9":            append([]byte{0x49, 0x01, 0x00, 0x01}, make([]byte, 9)...),
+		"truncated token":           {0x44, 0x01, 0x00, 0x01, 0xaa},
+		"empty message with token":  {0x41, 0x00, 0x00, 0x01, 0xaa},
+		"empty message with bytes":  {0x40, 0x00, 0x00, 0x01, 0xff, 0x01},
+		"marker without payload":    {0x40, 0x01, 0x00, 0x01, 0xff},
+		"reserved delta":            {0x40, 0x01, 0x00, 0x01, 0xf1, 0x00},
+		"reserved length":           {0x40, 0x01, 0x00, 0x01, 0x1f},
+		"truncated extended delta":  {0x40, 0x01, 0x00, 0x01, 0xe0, 0x01},
+		"truncated extended length": {0x40, 0x01, 0x00, 0x01, 0x1d},
+		"value beyond message":      {0x40, 0x01, 0x00, 0x01, 0xb4, 'r', 'd'},
+		// Two options with the largest delta add up past 65535
+		"option number overflow": {0x40, 0x01, 0x00, 0x01, 0xe0, 0xff, 0xff, 0xe0, 0xff, 0xff},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseMessage(data)
+			assert.ErrorIs(t, err, ErrMessageFormat)
+		})
+	}
+}
+
+func TestParseMessageCopies(t *testing.T) {
+	data := []byte{0x41, byte(GET), 0x00, 0x01, 0xaa, 0xb2, 'r', 'd', 0xff, 'x'}
+	message, err := ParseMessage(data)
+	require.NoError(t, err)
+	clear(data)
+	assert.Equal(t, []byte{0xaa}, message.Token)
+	assert.Equal(t, "/rd", message.GetPath())
+	assert.Equal(t, []byte("x"), message.Payload)
+}
+
+func TestEncodeRejectsLongOptions(t *testing.T) {
+	message := &Message{Type: Confirmable, Code: byte(GET)}
+	message.AddOption(UriQuery, make([]byte, maxOptionLength+1))
+	_, err := message.Encode()
+	assert.Error(t, err)
+}
@@ -7,9 +7,12 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/firmware"
+	"github.com/Skpow1234/Peervault/internal/lwm2m"
 )
 
 // Server represents the CoAP server
@@ -34,6 +37,18 @@ type Server struct {
 	stats   *ServerStats
 	statsMu sync.RWMutex
 
+	// LwM2M device management; nil unless the node runs it
+	lwm2m *lwm2m.Server
+	// Firmware distribution; nil unless the node runs it
+	firmware *firmware.Manager
+
+	// Requests the server sends to devices, by token, over the connection
+	// it serves
+	conn        atomic.Pointer[net.UDPConn]
+	exchanges   map[string]*exchange
+	exchangesMu sync.Mutex
+	messageID   atomic.Uint32
+
 	// Context for shutdown
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -77,6 +92,7 @@ func NewServer(fileserver *fileserver.Server, config *ServerConfig, logger *slog
 		resources:  make(map[string]*Resource),
 		observers:  make(map[string][]*Observer),
 		clients:    make(map[string]*Client),
+		exchanges:  make(map[string]*exchange),
 		stats: &ServerStats{
 			StartTime: time.Now(),
 		},
@@ -86,6 +102,15 @@ func NewServer(fileserver *fileserver.Server, config *ServerConfig, logger *slog
 
 	// Register default resources
 	server.registerDefaultResources()
+	if fileserver != nil && fileserver.Devices != nil {
+		server.registerESTResources(fileserver.Devices)
+	}
+	if fileserver != nil && fileserver.LwM2M != nil {
+		server.registerLwM2MResources(fileserver.LwM2M)
+	}
+	if fileserver != nil {
+		server.firmware = fileserver.Firmware
+	}
 
 	// Start background tasks
 	go server.startBackgroundTasks()
@@ -96,6 +121,7 @@ func NewServer(fileserver *fileserver.Server, config *ServerConfig, logger *slog
 // ServeUDP starts the UDP CoAP server
 func (s *Server) ServeUDP(ctx context.Context, conn *net.UDPConn) error {
 	buffer := make([]byte, s.config.MaxMessageSize)
+	s.conn.Store(conn)
 
 	for {
 		select {
@@ -118,8 +144,8 @@ func (s *Server) ServeUDP(ctx context.Context, conn *net.UDPConn) error {
 			return err
 		}
 
-		// Handle message in goroutine
-		go s.handleMessage(conn, clientAddr, buffer[:n])
+		// Handle message in goroutine, as the buffer is reused
+		go s.handleMessage(conn, clientAddr, append([]byte(nil), buffer[:n]...))
 	}
 }
 
@@ -146,6 +172,11 @@ func (s *Server) handleMessage(conn *net.UDPConn, clientAddr *net.UDPAddr, data
 		stats.BytesReceived += int64(len(data))
 	})
 
+	// Responses to the requests the server sent
+	if s.handleExchangeMessage(conn, clientAddr, message) {
+		return
+	}
+
 	// Get or create client
 	client := s.getOrCreateClient(clientAddr)
 
@@ -171,6 +202,13 @@ func (s *Server) handleMessage(conn *net.UDPConn, clientAddr *net.UDPAddr, data
 
 // handleRequest handles a CoAP request
 func (s *Server) handleRequest(message *Message, client *Client) (*Message, error) {
+	if s.lwm2m != nil && strings.HasPrefix(message.GetPath(), lwm2mRegistrationPath+"/") {
+		return s.handleLwM2MRegistration(message, client)
+	}
+	if s.firmware != nil && strings.HasPrefix(message.GetPath(), firmware.CoAPPath+"/") {
+		return s.handleFirmware(message)
+	}
+
 	// Find the resource
 	resource, exists := s.getResource(message.GetPath())
 	if !exists {
@@ -1,19 +1,27 @@
 package gateway
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/http/httputil"
 	"net/url"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/Skpow1234/Peervault/internal/api/apierror"
 	"github.com/Skpow1234/Peervault/internal/api/rest/ratelimit"
+	"github.com/Skpow1234/Peervault/internal/clientip"
+	"github.com/Skpow1234/Peervault/internal/requestid"
 )
 
 // Gateway represents an API gateway
@@ -23,6 +31,8 @@ type Gateway struct {
 	routes      map[string]*Route
 	middleware  []MiddlewareFunc
 	rateLimiter *ratelimit.RateLimiter
+	transport   *http.Transport
+	server      *http.Server
 	stopChan    chan struct{}
 	mu          sync.RWMutex
 }
@@ -36,10 +46,31 @@ type GatewayConfig struct {
 	RetryDelay      time.Duration
 	RateLimitConfig *ratelimit.RateLimitConfig
 	Routes          []RouteConfig
+	// AuthToken, when set, must be sent as a Bearer token on every request
+	// but CORS preflights and those under PublicPaths
+	AuthToken string
+	// PublicPaths are reachable without AuthToken; paths ending in a slash
+	// cover everything below them
+	PublicPaths []string
+	// TLSConfig serves the gateway over HTTPS; nil serves plain HTTP
+	TLSConfig *tls.Config
+	// UpstreamTLS configures connections to HTTPS upstreams
+	UpstreamTLS *tls.Config
+	// Router optionally redirects clients to a nearer node of the cluster
+	Router Router
+}
+
+// Router chooses the node a request is better served by
+type Router interface {
+	// Route returns the URL to redirect the request to; ok is false when
+	// the request is served here
+	Route(r *http.Request) (target *url.URL, ok bool)
 }
 
 // RouteConfig defines a route configuration
 type RouteConfig struct {
+	// Host restricts the route to requests for that host
+	Host         string            `json:"host,omitempty"`
 	Path         string            `json:"path"`
 	Methods      []string          `json:"methods"`
 	UpstreamURL  string            `json:"upstream_url"`
@@ -47,10 +78,14 @@ type RouteConfig struct {
 	AddHeaders   map[string]string `json:"add_headers,omitempty"`
 	Transform    *TransformConfig  `json:"transform,omitempty"`
 	RewriteRules []RewriteRule     `json:"rewrite_rules,omitempty"`
+	// PreserveHost sends the client's Host header upstream instead of the
+	// upstream's, so upstreams checking origins see the gateway's host
+	PreserveHost bool `json:"preserve_host,omitempty"`
 }
 
 // Route represents a configured route
 type Route struct {
+	Host         string
 	Path         string
 	Methods      map[string]bool
 	UpstreamURL  *url.URL
@@ -58,6 +93,9 @@ type Route struct {
 	AddHeaders   map[string]string
 	Transform    *TransformConfig
 	RewriteRules []RewriteRule
+	PreserveHost bool
+	// proxy relays WebSocket and event streams, which cannot be buffered
+	proxy *httputil.ReverseProxy
 }
 
 // TransformConfig defines request/response transformation rules
@@ -104,11 +142,14 @@ type RouteStats struct {
 
 // NewGateway creates a new API gateway
 func NewGateway(config *GatewayConfig, logger *slog.Logger) (*Gateway, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config.UpstreamTLS
 	gw := &Gateway{
 		config:     config,
 		logger:     logger,
 		routes:     make(map[string]*Route),
 		middleware: make([]MiddlewareFunc, 0),
+		transport:  transport,
 		stopChan:   make(chan struct{}),
 	}
 
@@ -123,15 +164,21 @@ func NewGateway(config *GatewayConfig, logger *slog.Logger) (*Gateway, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to create route for %s: %v", routeConfig.Path, err)
 		}
-		gw.routes[routeConfig.Path] = route
+		gw.routes[routeConfig.Host+routeConfig.Path] = route
 	}
 
-	// Add default middleware
+	// Add default middleware. CORS, CSRF protection and security headers
+	// are left to the upstreams, which answer preflights themselves.
 	gw.Use(gw.loggingMiddleware)
-	gw.Use(gw.corsMiddleware)
+	if config.AuthToken != "" {
+		gw.Use(gw.authMiddleware)
+	}
 	if gw.rateLimiter != nil {
 		gw.Use(gw.rateLimiter.Middleware())
 	}
+	if config.Router != nil {
+		gw.Use(gw.redirectMiddleware)
+	}
 
 	return gw, nil
 }
@@ -149,6 +196,7 @@ func (gw *Gateway) createRoute(config RouteConfig) (*Route, error) {
 	}
 
 	route := &Route{
+		Host:         config.Host,
 		Path:         config.Path,
 		Methods:      methods,
 		UpstreamURL:  upstreamURL,
@@ -156,6 +204,30 @@ func (gw *Gateway) createRoute(config RouteConfig) (*Route, error) {
 		AddHeaders:   config.AddHeaders,
 		Transform:    config.Transform,
 		RewriteRules: config.RewriteRules,
+		PreserveHost: config.PreserveHost,
+	}
+	route.proxy = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL = gw.rewriteURL(pr.In.URL, route)
+			if !route.PreserveHost {
+				pr.Out.Host = ""
+			}
+			pr.SetXForwarded()
+			for key, value := range route.AddHeaders {
+				pr.Out.Header.Set(key, value)
+			}
+			requestid.Propagate(pr.In.Context(), pr.Out)
+		},
+		Transport: gw.transport,
+		ModifyResponse: func(resp *http.Response) error {
+			// The gateway sent the same ID already
+			resp.Header.Del(requestid.Header)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			gw.logger.ErrorContext(r.Context(), "Upstream stream failed", "error", err, "path", r.URL.Path)
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		},
 	}
 
 	return route, nil
@@ -166,24 +238,40 @@ func (gw *Gateway) Use(middleware MiddlewareFunc) {
 	gw.middleware = append(gw.middleware, middleware)
 }
 
-// Start starts the API gateway
-func (gw *Gateway) Start() error {
+// Handler returns the gateway with its routes and middleware. Routes are
+// matched like http.ServeMux patterns, by host when the route names one.
+func (gw *Gateway) Handler() http.Handler {
 	mux := http.NewServeMux()
 
 	// Apply middleware
 	handler := gw.applyMiddleware(mux)
 
 	// Add routes
-	for path, route := range gw.routes {
-		mux.HandleFunc(path, gw.createRouteHandler(route))
+	for pattern, route := range gw.ListRoutes() {
+		mux.HandleFunc(pattern, gw.createRouteHandler(route))
 	}
 
-	server := &http.Server{
-		Addr:    gw.config.ListenAddr,
-		Handler: handler,
+	return requestid.Middleware(apierror.Middleware(handler))
+}
+
+// Start starts the API gateway
+func (gw *Gateway) Start() error {
+	handler := gw.Handler()
+	gw.mu.Lock()
+	gw.server = &http.Server{
+		Addr:              gw.config.ListenAddr,
+		Handler:           handler,
+		TLSConfig:         gw.config.TLSConfig,
+		ReadHeaderTimeout: 20 * time.Second,
+		IdleTimeout:       60 * time.Second,
 	}
+	server := gw.server
+	gw.mu.Unlock()
 
-	gw.logger.Info("Starting API gateway", "addr", gw.config.ListenAddr)
+	gw.logger.Info("Starting API gateway", "addr", gw.config.ListenAddr, "tls", gw.config.TLSConfig != nil)
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
 	return server.ListenAndServe()
 }
 
@@ -193,6 +281,13 @@ func (gw *Gateway) Stop(ctx context.Context) error {
 		gw.rateLimiter.Stop()
 	}
 	close(gw.stopChan)
+
+	gw.mu.RLock()
+	server := gw.server
+	gw.mu.RUnlock()
+	if server != nil {
+		return server.Shutdown(ctx)
+	}
 	return nil
 }
 
@@ -207,14 +302,21 @@ func (gw *Gateway) applyMiddleware(handler http.Handler) http.Handler {
 // createRouteHandler creates a handler for a specific route
 func (gw *Gateway) createRouteHandler(route *Route) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-
 		// Check if method is allowed
 		if !route.Methods[r.Method] && len(route.Methods) > 0 {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
+		// Streams are relayed as they come, without timeouts
+		if isStream(r) {
+			rc := http.NewResponseController(w)
+			_ = rc.SetReadDeadline(time.Time{})
+			_ = rc.SetWriteDeadline(time.Time{})
+			route.proxy.ServeHTTP(w, r)
+			return
+		}
+
 		// Apply transformations
 		if err := gw.applyRequestTransform(r, route.Transform); err != nil {
 			gw.logger.Error("Request transformation failed", "error", err)
@@ -228,7 +330,11 @@ func (gw *Gateway) createRouteHandler(route *Route) http.HandlerFunc {
 		// Create upstream request
 		upstreamReq := r.Clone(r.Context())
 		upstreamReq.URL = targetURL
-		upstreamReq.Host = targetURL.Host
+		if !route.PreserveHost {
+			upstreamReq.Host = targetURL.Host
+		}
+		setForwarded(upstreamReq, r)
+		requestid.Propagate(r.Context(), upstreamReq)
 
 		// Add headers
 		for key, value := range route.AddHeaders {
@@ -268,13 +374,28 @@ func (gw *Gateway) createRouteHandler(route *Route) http.HandlerFunc {
 
 		// Copy response
 		gw.copyResponse(w, resp)
+	}
+}
 
-		duration := time.Since(start)
-		gw.logger.Info("Request completed",
-			"path", r.URL.Path,
-			"method", r.Method,
-			"status", resp.StatusCode,
-			"duration", duration)
+// isStream reports whether r opens a WebSocket or an event stream
+func isStream(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" || strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// setForwarded tells the upstream who the client is and how it reached the
+// gateway
+func setForwarded(out, in *http.Request) {
+	if ip, _, err := net.SplitHostPort(in.RemoteAddr); err == nil {
+		if prior := out.Header.Get("X-Forwarded-For"); prior != "" {
+			ip = prior + ", " + ip
+		}
+		out.Header.Set("X-Forwarded-For", ip)
+	}
+	out.Header.Set("X-Forwarded-Host", in.Host)
+	if in.TLS != nil {
+		out.Header.Set("X-Forwarded-Proto", "https")
+	} else {
+		out.Header.Set("X-Forwarded-Proto", "http")
 	}
 }
 
@@ -302,7 +423,12 @@ func (gw *Gateway) rewriteURL(originalURL *url.URL, route *Route) *url.URL {
 // executeRequest executes an HTTP request with timeout
 func (gw *Gateway) executeRequest(req *http.Request) (*http.Response, error) {
 	client := &http.Client{
-		Timeout: gw.config.UpstreamTimeout,
+		Transport: gw.transport,
+		Timeout:   gw.config.UpstreamTimeout,
+		// Redirects are the client's to follow
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
 	}
 
 	// Clear RequestURI as it's not allowed in client requests
@@ -419,8 +545,11 @@ func (gw *Gateway) applyFieldTransformations(data map[string]interface{}, rule *
 
 // copyResponse copies the response from upstream to client
 func (gw *Gateway) copyResponse(w http.ResponseWriter, resp *http.Response) {
-	// Copy headers
+	// Copy headers; the gateway sent the request ID already
 	for key, values := range resp.Header {
+		if key == http.CanonicalHeaderKey(requestid.Header) {
+			continue
+		}
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
@@ -435,34 +564,109 @@ func (gw *Gateway) copyResponse(w http.ResponseWriter, resp *http.Response) {
 	}
 }
 
-// loggingMiddleware logs requests
+// loggingMiddleware writes an access log entry for every request once it
+// completes
 func (gw *Gateway) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		gw.logger.Info("Gateway request",
+		start := time.Now()
+		aw := &accessWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, r)
+		if aw.status == 0 {
+			aw.status = http.StatusOK
+		}
+		gw.logger.InfoContext(r.Context(), "Gateway request",
 			"method", r.Method,
+			"host", r.Host,
 			"path", r.URL.Path,
-			"remote_addr", r.RemoteAddr,
-			"user_agent", r.Header.Get("User-Agent"))
-		next.ServeHTTP(w, r)
+			"status", aw.status,
+			"bytes", aw.bytes,
+			"duration", time.Since(start),
+			"client_ip", clientip.FromRequest(r),
+			"user_agent", r.Header.Get("User-Agent"),
+			"request_id", requestid.FromContext(r.Context()))
 	})
 }
 
-// corsMiddleware adds CORS headers
-func (gw *Gateway) corsMiddleware(next http.Handler) http.Handler {
+// authMiddleware requires the gateway's token outside the public paths
+func (gw *Gateway) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, public := range gw.config.PublicPaths {
+			if r.URL.Path == public || (strings.HasSuffix(public, "/") && strings.HasPrefix(r.URL.Path, public)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(gw.config.AuthToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Invalid or missing authorization token", http.StatusUnauthorized)
 			return
 		}
-
 		next.ServeHTTP(w, r)
 	})
 }
 
+// redirectMiddleware sends clients to the node the router chooses with a
+// 307, which keeps the method and body
+func (gw *Gateway) redirectMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target, ok := gw.config.Router.Route(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, target.String(), http.StatusTemporaryRedirect)
+	})
+}
+
+// accessWriter records the status and size of a response
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets event streams through as they come
+func (w *accessWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades through
+func (w *accessWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *accessWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // GetStats returns gateway statistics
 func (gw *Gateway) GetStats() *GatewayStats {
 	return &GatewayStats{
@@ -480,16 +684,17 @@ func (gw *Gateway) AddRoute(config RouteConfig) error {
 		return err
 	}
 
-	gw.routes[config.Path] = route
+	gw.routes[config.Host+config.Path] = route
 	return nil
 }
 
-// RemoveRoute removes a route from the gateway
-func (gw *Gateway) RemoveRoute(path string) {
+// RemoveRoute removes a route from the gateway; pattern is the route's
+// host, if any, followed by its path
+func (gw *Gateway) RemoveRoute(pattern string) {
 	gw.mu.Lock()
 	defer gw.mu.Unlock()
 
-	delete(gw.routes, path)
+	delete(gw.routes, pattern)
 }
 
 // ListRoutes returns all configured routes
@@ -1,6 +1,7 @@
 package gateway
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -8,11 +9,16 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
+
 	"github.com/Skpow1234/Peervault/internal/api/rest/ratelimit"
+	"github.com/Skpow1234/Peervault/internal/geo"
 )
 
 // createTestLogger creates a test logger
@@ -413,3 +419,243 @@ func TestGatewayAddRemoveRoutes(t *testing.T) {
 		t.Error("Route should not exist after removing")
 	}
 }
+
+// serveGateway starts the gateway's full handler on a test server
+func serveGateway(t *testing.T, config *GatewayConfig, logger *slog.Logger) *httptest.Server {
+	t.Helper()
+	gw, err := NewGateway(config, logger)
+	if err != nil {
+		t.Fatalf("Failed to create gateway: %v", err)
+	}
+	srv := httptest.NewServer(gw.Handler())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGatewayHostRoutingAndForwarding(t *testing.T) {
+	upstream := func(name string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Upstream", name)
+			w.Header().Set("X-Seen-Host", r.Host)
+			w.Header().Set("X-Seen-Forwarded-For", r.Header.Get("X-Forwarded-For"))
+			w.Header().Set("X-Seen-Request-ID", r.Header.Get("X-Request-ID"))
+			w.Header().Set("X-Request-ID", "upstream-id")
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	rest, graphql := upstream("rest"), upstream("graphql")
+
+	srv := serveGateway(t, &GatewayConfig{
+		UpstreamTimeout: 5 * time.Second,
+		Routes: []RouteConfig{
+			{Path: "/", UpstreamURL: rest.URL, PreserveHost: true},
+			{Path: "/graphql", UpstreamURL: graphql.URL, PreserveHost: true},
+			{Host: "graphql.example.com", Path: "/", UpstreamURL: graphql.URL},
+		},
+	}, createTestLogger())
+
+	get := func(host, path string) *http.Response {
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		req.Host = host
+		req.Header.Set("X-Request-ID", "client-id")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	resp := get("vault.example.com", "/api/v1/files")
+	if got := resp.Header.Get("X-Upstream"); got != "rest" {
+		t.Errorf("Expected /api/v1/files to reach rest, got %q", got)
+	}
+	if got := resp.Header.Get("X-Seen-Host"); got != "vault.example.com" {
+		t.Errorf("Expected the client's host upstream, got %q", got)
+	}
+	if got := resp.Header.Get("X-Seen-Forwarded-For"); got != "127.0.0.1" {
+		t.Errorf("Expected X-Forwarded-For 127.0.0.1, got %q", got)
+	}
+	if got := resp.Header.Values("X-Request-ID"); len(got) != 1 || got[0] != "client-id" {
+		t.Errorf("Expected the client's request ID once, got %v", got)
+	}
+	if got := resp.Header.Get("X-Seen-Request-ID"); got != "client-id" {
+		t.Errorf("Expected the request ID upstream, got %q", got)
+	}
+
+	if got := get("vault.example.com", "/graphql").Header.Get("X-Upstream"); got != "graphql" {
+		t.Errorf("Expected /graphql to reach graphql, got %q", got)
+	}
+
+	resp = get("graphql.example.com", "/api/v1/files")
+	if got := resp.Header.Get("X-Upstream"); got != "graphql" {
+		t.Errorf("Expected graphql.example.com to reach graphql, got %q", got)
+	}
+	if got := resp.Header.Get("X-Seen-Host"); got != strings.TrimPrefix(graphql.URL, "http://") {
+		t.Errorf("Expected the upstream's host without PreserveHost, got %q", got)
+	}
+}
+
+func TestGatewayAuthAndAccessLog(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	var logs bytes.Buffer
+	srv := serveGateway(t, &GatewayConfig{
+		UpstreamTimeout: 5 * time.Second,
+		Routes:          []RouteConfig{{Path: "/", UpstreamURL: upstream.URL}},
+		AuthToken:       "secret",
+		PublicPaths:     []string{"/health", "/s/"},
+	}, slog.New(slog.NewJSONHandler(&logs, nil)))
+
+	status := func(path, token string) int {
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	tests := []struct {
+		path, token string
+		want        int
+	}{
+		{"/api/v1/files", "", http.StatusUnauthorized},
+		{"/api/v1/files", "wrong", http.StatusUnauthorized},
+		{"/api/v1/files", "secret", http.StatusOK},
+		{"/health", "", http.StatusOK},
+		{"/healthz", "", http.StatusUnauthorized},
+		{"/s/abc", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := status(tt.path, tt.token); got != tt.want {
+			t.Errorf("GET %s with token %q: expected %d, got %d", tt.path, tt.token, tt.want, got)
+		}
+	}
+
+	var entry map[string]any
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("Failed to parse access log %q: %v", lines[0], err)
+	}
+	if entry["path"] != "/api/v1/files" || entry["status"] != float64(http.StatusUnauthorized) || entry["request_id"] == "" {
+		t.Errorf("Unexpected access log entry: %v", entry)
+	}
+}
+
+func TestGatewayStreams(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sse":
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte("data: first\n\n"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		case "/ws":
+			conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			for {
+				kind, msg, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				_ = conn.WriteMessage(kind, msg)
+			}
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	srv := serveGateway(t, &GatewayConfig{
+		// Streams must outlive the timeout of buffered requests
+		UpstreamTimeout: 100 * time.Millisecond,
+		Routes:          []RouteConfig{{Path: "/", UpstreamURL: upstream.URL}},
+	}, createTestLogger())
+
+	// Events arrive while the stream is still open
+	req, _ := http.NewRequest("GET", srv.URL+"/sse", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("SSE request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "data: first\n" {
+		t.Errorf("Expected the first event, got %q (%v)", line, err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial failed: %v", err)
+	}
+	defer conn.Close()
+	time.Sleep(200 * time.Millisecond)
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("ping")); err != nil {
+		t.Fatalf("WebSocket write failed: %v", err)
+	}
+	_, msg, err := conn.ReadMessage()
+	if err != nil || string(msg) != "ping" {
+		t.Errorf("Expected the echo, got %q (%v)", msg, err)
+	}
+}
+
+func TestGatewayGeoRedirect(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	db := geo.NewDB()
+	db.AddNetwork(netip.MustParsePrefix("198.51.100.0/24"), geo.Location{Region: "eu-west"})
+	router := geo.NewRouter(geo.RouterOptions{
+		DB:    db,
+		Paths: []string{"/api/"},
+		Nodes: func() []geo.Node {
+			return []geo.Node{
+				{ID: "self", Region: "us-east", Self: true},
+				{ID: "dublin", Region: "eu-west", URL: "https://eu.example.com"},
+			}
+		},
+	})
+	gw, err := NewGateway(&GatewayConfig{
+		ListenAddr:      ":0",
+		UpstreamTimeout: 5 * time.Second,
+		Routes:          []RouteConfig{{Path: "/api/", Methods: []string{"GET"}, UpstreamURL: upstream.URL}},
+		Router:          router,
+	}, createTestLogger())
+	if err != nil {
+		t.Fatalf("Failed to create gateway: %v", err)
+	}
+	handler := gw.Handler()
+
+	req := httptest.NewRequest("GET", "/api/files/report.pdf?version=3", nil)
+	req.RemoteAddr = "198.51.100.20:5000"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("Expected status 307, got %d", w.Code)
+	}
+	if got := w.Header().Get("Location"); got != "https://eu.example.com/api/files/report.pdf?version=3" {
+		t.Errorf("Unexpected redirect to %s", got)
+	}
+
+	// Clients this node is nearest to are proxied as usual
+	req = httptest.NewRequest("GET", "/api/files/report.pdf", nil)
+	req.RemoteAddr = "192.0.2.1:5000"
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+}
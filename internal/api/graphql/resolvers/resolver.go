@@ -3,9 +3,16 @@ package resolvers
 import (
 	"context"
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Skpow1234/Peervault/internal/analytics"
 	"github.com/Skpow1234/Peervault/internal/api/graphql/types"
 	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+	"github.com/Skpow1234/Peervault/internal/telemetry"
 )
 
 // Resolver is the main resolver interface for GraphQL operations
@@ -20,6 +27,11 @@ type Resolver interface {
 	SystemMetrics(ctx context.Context) (*types.SystemMetrics, error)
 	PerformanceStats(ctx context.Context) (*types.PerformanceMetrics, error)
 	StorageStats(ctx context.Context) (*types.StorageMetrics, error)
+	AccessRollups(ctx context.Context, dimension types.AccessDimension, value *string, from *time.Time, to *time.Time) ([]*types.AccessRollup, error)
+	TopAccessed(ctx context.Context, dimension types.AccessDimension, from *time.Time, to *time.Time, limit *int) ([]*types.AccessTotal, error)
+	Telemetry(ctx context.Context, device *string, metric *string, from *time.Time, to *time.Time, interval *string, aggregate *types.TelemetryAggregate, groupBy []types.TelemetryDimension) ([]*types.TelemetrySeries, error)
+	StorageUsage(ctx context.Context, prefix *string, depth *int) ([]*types.StorageUsage, error)
+	TenantUsage(ctx context.Context) ([]*types.StorageUsage, error)
 	Health(ctx context.Context) (*types.HealthStatus, error)
 
 	// Mutation resolvers
@@ -79,9 +91,56 @@ func (r *BaseResolver) Nodes(ctx context.Context) ([]*types.Node, error) {
 	return nil, nil
 }
 
+// PeerNetwork maps the latency topology measured by the node
 func (r *BaseResolver) PeerNetwork(ctx context.Context) (*types.PeerNetwork, error) {
-	// TODO: Implement peer network retrieval logic
-	return nil, nil
+	if r.server == nil {
+		return nil, fmt.Errorf("peer network requires a PeerVault node")
+	}
+	topology := r.server.Topology()
+
+	network := &types.PeerNetwork{
+		Nodes:       make([]*types.Node, len(topology.Nodes)),
+		Connections: make([]*types.Connection, 0, len(topology.Links)),
+		Topology: &types.NetworkTopology{
+			TotalNodes:    len(topology.Nodes),
+			LatencyMatrix: topology.Matrix,
+		},
+	}
+	nodes := make(map[string]*types.Node, len(topology.Nodes))
+	for i, n := range topology.Nodes {
+		node := &types.Node{ID: n.ID, Address: n.Addr, Status: types.NodeStatusOnline}
+		if host, port, err := net.SplitHostPort(n.Addr); err == nil {
+			node.Address = host
+			node.Port, _ = strconv.Atoi(port)
+		}
+		network.Nodes[i] = node
+		nodes[n.ID] = node
+	}
+
+	var total float64
+	for _, l := range topology.Links {
+		status := types.ConnectionStatusActive
+		if l.Loss >= 0.5 {
+			status = types.ConnectionStatusFailed
+		}
+		latency, updated := l.RTTMs, l.UpdatedAt
+		network.Connections = append(network.Connections, &types.Connection{
+			From:         nodes[l.From],
+			To:           nodes[l.To],
+			Status:       status,
+			Latency:      &latency,
+			LastActivity: &updated,
+		})
+		if l.From == topology.Self {
+			network.Topology.ConnectedNodes++
+		}
+		total += l.RTTMs
+	}
+	if len(topology.Links) > 0 {
+		average := total / float64(len(topology.Links))
+		network.Topology.AverageLatency = &average
+	}
+	return network, nil
 }
 
 func (r *BaseResolver) SystemMetrics(ctx context.Context) (*types.SystemMetrics, error) {
@@ -99,6 +158,169 @@ func (r *BaseResolver) StorageStats(ctx context.Context) (*types.StorageMetrics,
 	return nil, nil
 }
 
+// AccessRollups returns the access rollups collected by the node
+func (r *BaseResolver) AccessRollups(ctx context.Context, dimension types.AccessDimension, value *string, from *time.Time, to *time.Time) ([]*types.AccessRollup, error) {
+	q, err := r.accessQuery(dimension, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if value != nil {
+		q.Value = *value
+	}
+	rollups, err := r.server.Analytics.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*types.AccessRollup, len(rollups))
+	for i, ro := range rollups {
+		result[i] = &types.AccessRollup{Start: ro.Start, Value: ro.Value, Reads: ro.Reads, Writes: ro.Writes, Deletes: ro.Deletes, BytesRead: ro.BytesRead, BytesWritten: ro.BytesWritten}
+	}
+	return result, nil
+}
+
+// TopAccessed returns the most accessed keys, tenants or peers, 10 unless
+// limit is given
+func (r *BaseResolver) TopAccessed(ctx context.Context, dimension types.AccessDimension, from *time.Time, to *time.Time, limit *int) ([]*types.AccessTotal, error) {
+	q, err := r.accessQuery(dimension, from, to)
+	if err != nil {
+		return nil, err
+	}
+	n := 10
+	if limit != nil {
+		n = *limit
+	}
+	totals, err := r.server.Analytics.Top(q, n)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*types.AccessTotal, len(totals))
+	for i, t := range totals {
+		result[i] = &types.AccessTotal{Value: t.Value, Reads: t.Reads, Writes: t.Writes, Deletes: t.Deletes, BytesRead: t.BytesRead, BytesWritten: t.BytesWritten}
+	}
+	return result, nil
+}
+
+func (r *BaseResolver) accessQuery(dimension types.AccessDimension, from *time.Time, to *time.Time) (analytics.Query, error) {
+	if r.server == nil || r.server.Analytics == nil {
+		return analytics.Query{}, fmt.Errorf("access analytics are not collected by this node")
+	}
+	dim, err := analytics.ParseDimension(strings.ToLower(string(dimension)))
+	if err != nil {
+		return analytics.Query{}, err
+	}
+	q := analytics.Query{Dimension: dim}
+	if from != nil {
+		q.From = *from
+	}
+	if to != nil {
+		q.To = *to
+	}
+	return q, nil
+}
+
+// Telemetry returns the readings devices reported, downsampled to interval
+// and grouped by device and metric, with the aggregate, the average unless
+// given, as the value of each point
+func (r *BaseResolver) Telemetry(ctx context.Context, device *string, metric *string, from *time.Time, to *time.Time, interval *string, aggregate *types.TelemetryAggregate, groupBy []types.TelemetryDimension) ([]*types.TelemetrySeries, error) {
+	if r.server == nil || r.server.Telemetry == nil {
+		return nil, fmt.Errorf("telemetry is not collected by this node")
+	}
+	var q telemetry.Query
+	if device != nil {
+		q.Device = *device
+	}
+	if metric != nil {
+		q.Metric = *metric
+	}
+	if from != nil {
+		q.From = *from
+	}
+	if to != nil {
+		q.To = *to
+	}
+	if interval != nil && *interval != "" {
+		d, err := time.ParseDuration(*interval)
+		if err != nil {
+			return nil, fmt.Errorf("%w: interval: %w", telemetry.ErrInvalidQuery, err)
+		}
+		q.Interval = d
+	}
+	for _, g := range groupBy {
+		dim, err := telemetry.ParseDimension(strings.ToLower(string(g)))
+		if err != nil {
+			return nil, err
+		}
+		q.GroupBy = append(q.GroupBy, dim)
+	}
+	agg, name := telemetry.Avg, types.TelemetryAggregateAvg
+	if aggregate != nil {
+		var err error
+		if agg, err = telemetry.ParseAggregate(strings.ToLower(string(*aggregate))); err != nil {
+			return nil, err
+		}
+		name = *aggregate
+	}
+
+	series, err := r.server.Telemetry.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*types.TelemetrySeries, len(series))
+	for i, s := range series {
+		ts := &types.TelemetrySeries{Aggregate: name, Points: make([]*types.TelemetryPoint, len(s.Points))}
+		if s.Device != "" {
+			ts.Device = &s.Device
+		}
+		if s.Metric != "" {
+			ts.Metric = &s.Metric
+		}
+		for j, p := range s.Points {
+			ts.Points[j] = &types.TelemetryPoint{Start: p.Start, Value: agg.Value(p.Stats), Count: p.Count, Avg: p.Avg(), Min: p.Min, Max: p.Max, Sum: p.Sum}
+		}
+		result[i] = ts
+	}
+	return result, nil
+}
+
+// StorageUsage returns the usage under a directory prefix, every file
+// unless given, then that of the directories up to depth levels below it
+func (r *BaseResolver) StorageUsage(ctx context.Context, prefix *string, depth *int) ([]*types.StorageUsage, error) {
+	if r.server == nil || r.server.Metadata == nil {
+		return nil, fmt.Errorf("file metadata is not recorded by this node")
+	}
+	var p string
+	if prefix != nil {
+		p = *prefix
+	}
+	var d int
+	if depth != nil {
+		d = *depth
+	}
+	usage := r.server.Metadata.Usage(p, d)
+	result := make([]*types.StorageUsage, len(usage))
+	for i, u := range usage {
+		result[i] = storageUsage(u.Prefix, u.Usage)
+	}
+	return result, nil
+}
+
+// TenantUsage returns the usage of every tenant
+func (r *BaseResolver) TenantUsage(ctx context.Context) ([]*types.StorageUsage, error) {
+	if r.server == nil || r.server.Metadata == nil {
+		return nil, fmt.Errorf("file metadata is not recorded by this node")
+	}
+	usage := r.server.Metadata.TenantUsage()
+	result := make([]*types.StorageUsage, len(usage))
+	for i, u := range usage {
+		result[i] = storageUsage(u.Tenant, u.Usage)
+	}
+	return result, nil
+}
+
+func storageUsage(name string, u metadata.Usage) *types.StorageUsage {
+	return &types.StorageUsage{Name: name, Objects: u.Objects, Bytes: u.Bytes, Versions: u.Versions, VersionBytes: u.VersionBytes}
+}
+
 func (r *BaseResolver) Health(ctx context.Context) (*types.HealthStatus, error) {
 	// TODO: Implement health check logic
 	return nil, nil
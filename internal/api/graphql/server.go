@@ -2,15 +2,21 @@ package graphql
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/Skpow1234/Peervault/internal/api/apierror"
 	"github.com/Skpow1234/Peervault/internal/api/graphql/subscriptions"
+	"github.com/Skpow1234/Peervault/internal/api/httpguard"
 	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/requestid"
 	"github.com/Skpow1234/Peervault/internal/websocket"
 )
 
@@ -22,6 +28,7 @@ type Server struct {
 	hub                  *websocket.Hub
 	subscriptionManager  *websocket.SubscriptionManager
 	subscriptionResolver *subscriptions.SubscriptionResolver
+	httpServer           *http.Server
 }
 
 // Config holds the configuration for the GraphQL server
@@ -33,6 +40,10 @@ type Config struct {
 	AllowedOrigins   []string
 	EnablePlayground bool
 	EnableWebSocket  bool
+	// Security sets the CSRF protection and security headers of responses
+	Security httpguard.Policy
+	// TLSConfig serves the API over HTTPS; nil serves plain HTTP
+	TLSConfig *tls.Config
 }
 
 // DefaultConfig returns the default configuration
@@ -45,6 +56,7 @@ func DefaultConfig() *Config {
 		AllowedOrigins:   []string{"*"},
 		EnablePlayground: true,
 		EnableWebSocket:  true,
+		Security:         httpguard.DefaultPolicy(),
 	}
 }
 
@@ -83,17 +95,17 @@ func (s *Server) Start(config *Config) error {
 	mux := http.NewServeMux()
 
 	// GraphQL endpoint
-	mux.HandleFunc(config.GraphQLPath, s.CORSMiddleware(s.GraphQLHandler))
+	mux.HandleFunc(config.GraphQLPath, s.GraphQLHandler)
 
 	// WebSocket endpoint for GraphQL subscriptions
 	if config.EnableWebSocket {
 		wsHandler := websocket.NewGraphQLSubscriptionHandler(s.hub, s.logger)
-		mux.HandleFunc(config.WebSocketPath, s.CORSMiddleware(wsHandler.ServeHTTP))
+		mux.HandleFunc(config.WebSocketPath, wsHandler.ServeHTTP)
 	}
 
 	// GraphQL Playground
 	if config.EnablePlayground {
-		mux.HandleFunc(config.PlaygroundPath, s.CORSMiddleware(s.PlaygroundHandler))
+		mux.HandleFunc(config.PlaygroundPath, s.PlaygroundHandler)
 	}
 
 	// Health check endpoint
@@ -110,31 +122,37 @@ func (s *Server) Start(config *Config) error {
 		"websocketEnabled", config.EnableWebSocket,
 	)
 
-	server := &http.Server{
+	s.httpServer = &http.Server{
 		Addr:              fmt.Sprintf(":%d", config.Port),
-		Handler:           mux,
+		Handler:           requestid.Middleware(apierror.Middleware(s.CORSMiddleware(mux.ServeHTTP))),
 		ReadHeaderTimeout: 20 * time.Second,
 		ReadTimeout:       30 * time.Second,
 		WriteTimeout:      30 * time.Second,
 		IdleTimeout:       60 * time.Second,
+		TLSConfig:         config.TLSConfig,
 	}
-	return server.ListenAndServe()
+	var err error
+	if config.TLSConfig != nil {
+		err = s.httpServer.ListenAndServeTLS("", "")
+	} else {
+		err = s.httpServer.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
-// CORSMiddleware adds CORS headers to responses
+// CORSMiddleware answers CORS requests from the allowed origins and
+// applies CSRF protection and security headers
 func (s *Server) CORSMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	}
+	return httpguard.Middleware(&httpguard.Config{
+		Policy:         s.config.Security,
+		AllowedOrigins: s.config.AllowedOrigins,
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", requestid.Header},
+		ExposedHeaders: []string{requestid.Header},
+	}, next).ServeHTTP
 }
 
 // GraphQLRequest represents a GraphQL request
@@ -173,7 +191,11 @@ func (s *Server) GraphQLHandler(w http.ResponseWriter, r *http.Request) {
 
 	var req GraphQLRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		s.writeErrors(w, http.StatusBadRequest, apierror.New(r.Context(), apierror.CodeInvalidArgument, "Invalid JSON"))
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		s.writeErrors(w, http.StatusBadRequest, apierror.New(r.Context(), apierror.CodeInvalidArgument, "Missing query"))
 		return
 	}
 
@@ -194,6 +216,38 @@ func (s *Server) GraphQLHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// NewGraphQLError reports e in the shared error model: the message as
+// the GraphQL message, the code, request ID and details as extensions
+func NewGraphQLError(e *apierror.Error) GraphQLError {
+	extensions := map[string]interface{}{"code": e.Code}
+	if e.RequestID != "" {
+		extensions["request_id"] = e.RequestID
+	}
+	if len(e.Details) > 0 {
+		extensions["details"] = e.Details
+	}
+	return GraphQLError{Message: e.Message, Extensions: extensions}
+}
+
+// writeErrors writes a response carrying only errors
+func (s *Server) writeErrors(w http.ResponseWriter, status int, errs ...*apierror.Error) {
+	response := GraphQLResponse{}
+	for _, e := range errs {
+		response.Errors = append(response.Errors, NewGraphQLError(e))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Error("Failed to encode GraphQL errors", "error", err)
+	}
+}
+
+// playgroundCSP lets the playground load its bundle from the CDN and query
+// the API
+const playgroundCSP = "default-src 'self'; script-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net; " +
+	"style-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net https://fonts.googleapis.com; font-src 'self' data: https://fonts.gstatic.com; " +
+	"img-src 'self' data: https://cdn.jsdelivr.net; connect-src 'self' ws: wss:; frame-ancestors 'none'"
+
 // PlaygroundHandler serves the GraphQL Playground
 func (s *Server) PlaygroundHandler(w http.ResponseWriter, r *http.Request) {
 	playgroundHTML := `
@@ -217,6 +271,7 @@ func (s *Server) PlaygroundHandler(w http.ResponseWriter, r *http.Request) {
 </html>`
 
 	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Content-Security-Policy", playgroundCSP)
 	if _, err := w.Write([]byte(playgroundHTML)); err != nil {
 		http.Error(w, "Failed to write response", http.StatusInternalServerError)
 		return
@@ -331,6 +386,10 @@ func (s *Server) HandleFileDownload(w http.ResponseWriter, r *http.Request) {
 // Stop gracefully stops the server
 func (s *Server) Stop() error {
 	s.logger.Info("Stopping GraphQL server")
-	// TODO: Implement graceful shutdown
-	return nil
+	if s.httpServer == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return s.httpServer.Shutdown(ctx)
 }
@@ -107,6 +107,9 @@ type NetworkTopology struct {
 	AverageLatency  *float64   `json:"averageLatency"`
 	NetworkDiameter *int       `json:"networkDiameter"`
 	Clusters        []*Cluster `json:"clusters"`
+	// LatencyMatrix holds the round trip times in milliseconds between the
+	// nodes of the peer network, in the order of its nodes
+	LatencyMatrix [][]*float64 `json:"latencyMatrix"`
 }
 
 // Cluster represents a cluster of nodes
@@ -151,6 +154,85 @@ type PerformanceMetrics struct {
 	CPUUsage            *float64 `json:"cpuUsage"`
 }
 
+// AccessDimension is what access rollups are grouped by
+type AccessDimension string
+
+const (
+	AccessDimensionKey    AccessDimension = "KEY"
+	AccessDimensionTenant AccessDimension = "TENANT"
+	AccessDimensionPeer   AccessDimension = "PEER"
+)
+
+// AccessRollup counts the accesses to a key, tenant or peer during a bucket
+type AccessRollup struct {
+	Start        time.Time `json:"start"`
+	Value        string    `json:"value"`
+	Reads        int64     `json:"reads"`
+	Writes       int64     `json:"writes"`
+	Deletes      int64     `json:"deletes"`
+	BytesRead    int64     `json:"bytesRead"`
+	BytesWritten int64     `json:"bytesWritten"`
+}
+
+// AccessTotal counts the accesses to a key, tenant or peer over a range
+type AccessTotal struct {
+	Value        string `json:"value"`
+	Reads        int64  `json:"reads"`
+	Writes       int64  `json:"writes"`
+	Deletes      int64  `json:"deletes"`
+	BytesRead    int64  `json:"bytesRead"`
+	BytesWritten int64  `json:"bytesWritten"`
+}
+
+// TelemetryAggregate is how the readings of an interval are summed up
+type TelemetryAggregate string
+
+const (
+	TelemetryAggregateAvg   TelemetryAggregate = "AVG"
+	TelemetryAggregateMin   TelemetryAggregate = "MIN"
+	TelemetryAggregateMax   TelemetryAggregate = "MAX"
+	TelemetryAggregateSum   TelemetryAggregate = "SUM"
+	TelemetryAggregateCount TelemetryAggregate = "COUNT"
+)
+
+// TelemetryDimension is what telemetry series are grouped by
+type TelemetryDimension string
+
+const (
+	TelemetryDimensionDevice TelemetryDimension = "DEVICE"
+	TelemetryDimensionMetric TelemetryDimension = "METRIC"
+)
+
+// TelemetryPoint summarizes the readings of a series during an interval;
+// Value is the aggregate the query asked for
+type TelemetryPoint struct {
+	Start time.Time `json:"start"`
+	Value float64   `json:"value"`
+	Count int64     `json:"count"`
+	Avg   float64   `json:"avg"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Sum   float64   `json:"sum"`
+}
+
+// TelemetrySeries are the points of a device, a metric or both
+type TelemetrySeries struct {
+	Device    *string            `json:"device"`
+	Metric    *string            `json:"metric"`
+	Aggregate TelemetryAggregate `json:"aggregate"`
+	Points    []*TelemetryPoint  `json:"points"`
+}
+
+// StorageUsage counts the files and bytes under a directory prefix or of a
+// tenant; Name is the prefix or the tenant
+type StorageUsage struct {
+	Name         string `json:"name"`
+	Objects      int64  `json:"objects"`
+	Bytes        int64  `json:"bytes"`
+	Versions     int64  `json:"versions"`
+	VersionBytes int64  `json:"versionBytes"`
+}
+
 // FileUpload represents a file upload operation
 type FileUpload struct {
 	ID         string         `json:"id"`
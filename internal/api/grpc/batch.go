@@ -0,0 +1,112 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Skpow1234/Peervault/internal/batch"
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"github.com/Skpow1234/Peervault/proto/peervault"
+)
+
+// BatchExecute runs mixed file operations with bounded parallelism. Each
+// operation gets a result with its own status code; one failing does not
+// stop the others. Malformed operations fail the whole batch with
+// InvalidArgument before any of it runs.
+func (s *PeerVaultServiceImpl) BatchExecute(ctx context.Context, req *peervault.BatchRequest) (*peervault.BatchResponse, error) {
+	s.logger.Info("Executing batch", "operations", len(req.Operations), "parallelism", req.Parallelism)
+
+	ops := batchOperations(req)
+	if err := batch.Validate(ops); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	outcomes := s.fileService.ExecuteBatch(ctx, ops, int(req.Parallelism))
+	return batchResponse(ops, outcomes), nil
+}
+
+// handleBatch runs a batch in HTTP/JSON mode, see BatchExecute
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	var req peervault.BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	ops := batchOperations(&req)
+	if err := batch.Validate(ops); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	outcomes := s.fileService.ExecuteBatch(r.Context(), ops, int(req.Parallelism))
+	s.writeJSON(w, batchResponse(ops, outcomes))
+}
+
+func batchOperations(req *peervault.BatchRequest) []batch.Operation {
+	ops := make([]batch.Operation, len(req.Operations))
+	for i, op := range req.Operations {
+		if op == nil {
+			continue
+		}
+		ops[i] = batch.Operation{
+			Op:          op.Op,
+			Key:         op.Key,
+			Content:     op.Content,
+			URL:         op.Url,
+			ContentType: op.ContentType,
+			Tags:        op.Tags,
+			Metadata:    op.Metadata,
+			From:        op.From,
+			To:          op.To,
+			Tenant:      op.Tenant,
+			Overwrite:   op.Overwrite,
+			AddTags:     op.AddTags,
+			RemoveTags:  op.RemoveTags,
+			Set:         op.Set,
+			Remove:      op.Remove,
+		}
+	}
+	return ops
+}
+
+func batchResponse(ops []batch.Operation, outcomes []batch.Outcome) *peervault.BatchResponse {
+	response := &peervault.BatchResponse{Results: make([]*peervault.BatchResult, len(outcomes))}
+	for i, outcome := range outcomes {
+		code := batchCode(outcome.Err)
+		result := &peervault.BatchResult{Index: int32(i), Op: ops[i].Op, Key: ops[i].Target(), Code: code.String()}
+		if outcome.Err != nil {
+			result.Error = outcome.Err.Error()
+			response.Failed++
+		} else {
+			result.File, _ = outcome.Value.(*peervault.FileResponse)
+			response.Succeeded++
+		}
+		response.Results[i] = result
+	}
+	return response
+}
+
+// batchCode returns the status code of an operation of a batch
+func batchCode(err error) codes.Code {
+	switch {
+	case err == nil:
+		return codes.OK
+	case errors.Is(err, directory.ErrNotFound):
+		return codes.NotFound
+	case errors.Is(err, directory.ErrExists):
+		return codes.AlreadyExists
+	case errors.Is(err, batch.ErrInvalid), errors.Is(err, directory.ErrInvalidPath):
+		return codes.InvalidArgument
+	case errors.Is(err, batch.ErrFetch):
+		return codes.Unavailable
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	default:
+		return codes.Internal
+	}
+}
@@ -0,0 +1,87 @@
+package grpc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/directory"
+)
+
+// directoryTransfer is the body of copy and move requests
+type directoryTransfer struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Overwrite bool   `json:"overwrite"`
+}
+
+func (s *Server) handleListDirectory(w http.ResponseWriter, r *http.Request) {
+	listing, err := s.fileService.Directories().List(r.Context(), r.PathValue("path"), r.URL.Query().Get("recursive") == "true")
+	if err != nil {
+		s.writeDirectoryError(w, err)
+		return
+	}
+	s.writeJSON(w, listing)
+}
+
+func (s *Server) handleMakeDirectory(w http.ResponseWriter, r *http.Request) {
+	dir, err := s.fileService.Directories().Mkdir(r.Context(), r.PathValue("path"))
+	if err != nil {
+		s.writeDirectoryError(w, err)
+		return
+	}
+	s.writeJSON(w, dir)
+}
+
+func (s *Server) handleDeleteDirectory(w http.ResponseWriter, r *http.Request) {
+	report, err := s.fileService.Directories().Delete(r.Context(), r.PathValue("path"), r.URL.Query().Get("recursive") == "true")
+	if err != nil {
+		s.writeDirectoryError(w, err)
+		return
+	}
+	s.writeJSON(w, report)
+}
+
+func (s *Server) handleCopyDirectory(w http.ResponseWriter, r *http.Request) {
+	var req directoryTransfer
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.From == "" {
+		http.Error(w, "from is required", http.StatusBadRequest)
+		return
+	}
+	report, err := s.fileService.Directories().Copy(r.Context(), req.From, req.To, req.Overwrite)
+	if err != nil {
+		s.writeDirectoryError(w, err)
+		return
+	}
+	s.writeJSON(w, report)
+}
+
+func (s *Server) handleMoveDirectory(w http.ResponseWriter, r *http.Request) {
+	var req directoryTransfer
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.From == "" {
+		http.Error(w, "from is required", http.StatusBadRequest)
+		return
+	}
+	report, err := s.fileService.Directories().Move(r.Context(), req.From, req.To, req.Overwrite)
+	if err != nil {
+		s.writeDirectoryError(w, err)
+		return
+	}
+	s.writeJSON(w, report)
+}
+
+// writeDirectoryError answers with the status the REST API uses for the
+// same rejection
+func (s *Server) writeDirectoryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, directory.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, directory.ErrNotEmpty), errors.Is(err, directory.ErrExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, directory.ErrInvalidPath):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		s.logger.Error("Directory request failed", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -2,16 +2,21 @@ package grpc
 
 import (
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/Skpow1234/Peervault/internal/api/grpc/services"
+	"github.com/Skpow1234/Peervault/internal/etag"
+	"github.com/Skpow1234/Peervault/internal/watch"
 	"github.com/Skpow1234/Peervault/proto/peervault"
 )
 
@@ -43,6 +48,7 @@ func NewPeerVaultServiceImpl(logger *slog.Logger) *PeerVaultServiceImpl {
 // UploadFile implements streaming file upload
 func (s *PeerVaultServiceImpl) UploadFile(stream peervault.PeerVaultService_UploadFileServer) error {
 	var fileKey string
+	var cond etag.Condition
 	var fileData []byte
 	var totalSize int64
 
@@ -60,6 +66,7 @@ func (s *PeerVaultServiceImpl) UploadFile(stream peervault.PeerVaultService_Uplo
 
 		if fileKey == "" {
 			fileKey = chunk.FileKey
+			cond = etag.Condition{IfMatch: chunk.IfMatch, IfNoneMatch: chunk.IfNoneMatch}
 		}
 
 		fileData = append(fileData, chunk.Data...)
@@ -72,9 +79,12 @@ func (s *PeerVaultServiceImpl) UploadFile(stream peervault.PeerVaultService_Uplo
 	}
 
 	// Process the uploaded file
-	response, err := s.fileService.UploadFile(fileKey, fileData)
+	response, err := s.fileService.UploadFileIf(fileKey, fileData, cond)
 	if err != nil {
 		s.logger.Error("Error uploading file", "file_key", fileKey, "error", err)
+		if errors.Is(err, etag.ErrPrecondition) {
+			return status.Error(codes.FailedPrecondition, err.Error())
+		}
 		return status.Error(codes.Internal, "failed to upload file")
 	}
 
@@ -91,6 +101,13 @@ func (s *PeerVaultServiceImpl) DownloadFile(req *peervault.FileRequest, stream p
 		s.logger.Error("Error downloading file", "file_key", req.Key, "error", err)
 		return status.Error(codes.NotFound, "file not found")
 	}
+	hash := fmt.Sprintf("%x", sha256.Sum256(fileData))
+	if err := checkRead(req, hash); err != nil {
+		return err
+	}
+	if err := stream.SendHeader(metadata.Pairs("etag", hash)); err != nil {
+		return err
+	}
 
 	// Send file in chunks
 	chunkSize := 64 * 1024 // 64KB chunks
@@ -144,17 +161,40 @@ func (s *PeerVaultServiceImpl) GetFile(ctx context.Context, req *peervault.FileR
 		s.logger.Error("Error getting file", "file_key", req.Key, "error", err)
 		return nil, status.Error(codes.NotFound, "file not found")
 	}
+	if err := checkRead(req, response.Hash); err != nil {
+		return nil, err
+	}
 
 	return response, nil
 }
 
+// checkRead checks the If-Match and If-None-Match of a read against the
+// ETag of the file, the hash of its content. Without a status answering
+// "not modified", a read whose If-None-Match holds fails with
+// FailedPrecondition like one whose If-Match does not.
+func checkRead(req *peervault.FileRequest, hash string) error {
+	cond := etag.Condition{IfMatch: req.IfMatch, IfNoneMatch: req.IfNoneMatch}
+	notModified, err := cond.CheckRead(hash)
+	if err != nil {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if notModified {
+		return status.Error(codes.FailedPrecondition, "not modified")
+	}
+	return nil
+}
+
 // DeleteFile implements file deletion
 func (s *PeerVaultServiceImpl) DeleteFile(ctx context.Context, req *peervault.FileRequest) (*peervault.DeleteFileResponse, error) {
 	s.logger.Info("Deleting file", "file_key", req.Key)
 
-	success, err := s.fileService.DeleteFile(req.Key)
+	cond := etag.Condition{IfMatch: req.IfMatch, IfNoneMatch: req.IfNoneMatch}
+	success, err := s.fileService.DeleteFileIf(req.Key, cond)
 	if err != nil {
 		s.logger.Error("Error deleting file", "file_key", req.Key, "error", err)
+		if errors.Is(err, etag.ErrPrecondition) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
 		return nil, status.Error(codes.Internal, "failed to delete file")
 	}
 
@@ -441,3 +481,43 @@ func (s *PeerVaultServiceImpl) StreamSystemEvents(req *emptypb.Empty, stream pee
 		}
 	}
 }
+
+// Watch streams the changes to the keys under a prefix. The watch-token
+// header holds the token the watch starts from. A client that falls behind
+// gets ResourceExhausted and resumes with the token of the last event it
+// received; FailedPrecondition means the token expired and the client lists
+// the files again.
+func (s *PeerVaultServiceImpl) Watch(req *peervault.WatchRequest, stream peervault.PeerVaultService_WatchServer) error {
+	ctx := stream.Context()
+	token := req.Token
+	if token == "" {
+		token = s.fileService.WatchToken()
+	}
+	events, err := s.fileService.Watch(ctx, req.Prefix, token)
+	switch {
+	case errors.Is(err, watch.ErrExpired):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case err != nil:
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := stream.SendHeader(metadata.Pairs("watch-token", token)); err != nil {
+		return err
+	}
+
+	for e := range events {
+		if err := stream.Send(&peervault.WatchEvent{
+			Op:        string(e.Op),
+			Key:       e.Key,
+			Size:      e.Size,
+			Token:     e.Token,
+			Timestamp: timestamppb.New(e.Time),
+		}); err != nil {
+			s.logger.Error("Error sending watch event", "error", err)
+			return status.Error(codes.Internal, "failed to send event")
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return status.Error(codes.ResourceExhausted, "the client fell behind; watch again with the token of the last event")
+}
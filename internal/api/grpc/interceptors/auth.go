@@ -4,7 +4,9 @@ import (
 	"context"
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"strings"
@@ -27,7 +29,10 @@ const (
 
 // AuthConfig represents authentication configuration
 type AuthConfig struct {
-	SecretKey      string
+	// SecretKey signs HS256 JWTs; empty rejects every JWT
+	SecretKey string
+	// Tokens maps static bearer tokens to the user IDs they authenticate
+	Tokens         map[string]string
 	TokenExpiry    time.Duration
 	Issuer         string
 	Audience       string
@@ -45,7 +50,7 @@ func DefaultAuthConfig() *AuthConfig {
 		Issuer:         "peervault",
 		Audience:       "peervault-api",
 		RequireAuth:    true,
-		SkipMethods:    []string{"/peervault.PeerVaultService/HealthCheck"},
+		SkipMethods:    []string{"/peervault.PeerVaultService/HealthCheck", "/grpc.health.v1.Health/"},
 		RateLimitRPS:   100,
 		RateLimitBurst: 200,
 	}
@@ -91,9 +96,7 @@ func (ai *AuthInterceptor) UnaryAuthInterceptor() grpc.UnaryServerInterceptor {
 		}
 
 		// Add claims to context
-		ctx = context.WithValue(ctx, userIDKey, claims.UserID)
-		ctx = context.WithValue(ctx, userRolesKey, claims.Roles)
-		ctx = context.WithValue(ctx, tokenExpiryKey, claims.ExpiresAt)
+		ctx = withClaims(ctx, claims)
 
 		// Log successful authentication
 		ai.logger.Info("Authentication successful", "method", info.FullMethod, "user_id", claims.UserID)
@@ -118,9 +121,7 @@ func (ai *AuthInterceptor) StreamAuthInterceptor() grpc.StreamServerInterceptor
 		}
 
 		// Add claims to context
-		ctx := context.WithValue(ss.Context(), userIDKey, claims.UserID)
-		ctx = context.WithValue(ctx, userRolesKey, claims.Roles)
-		ctx = context.WithValue(ctx, tokenExpiryKey, claims.ExpiresAt)
+		ctx := withClaims(ss.Context(), claims)
 
 		// Create new stream with updated context
 		wrappedStream := &wrappedServerStream{
@@ -135,18 +136,35 @@ func (ai *AuthInterceptor) StreamAuthInterceptor() grpc.StreamServerInterceptor
 	}
 }
 
+// withClaims returns ctx carrying the claims of the authenticated user, and
+// tells interceptors further out who the user is
+func withClaims(ctx context.Context, claims *TokenClaims) context.Context {
+	if info, ok := ctx.Value(callInfoKey).(*callInfo); ok {
+		info.userID = claims.UserID
+	}
+	ctx = context.WithValue(ctx, userIDKey, claims.UserID)
+	ctx = context.WithValue(ctx, userRolesKey, claims.Roles)
+	return context.WithValue(ctx, tokenExpiryKey, claims.ExpiresAt)
+}
+
 // shouldSkipAuth checks if the method should skip authentication
 func (ai *AuthInterceptor) shouldSkipAuth(method string) bool {
-	if !ai.config.RequireAuth {
+	if !ai.RequireAuth {
 		return true
 	}
 
-	for _, skipMethod := range ai.config.SkipMethods {
-		if method == skipMethod {
+	return matchesMethod(ai.config.SkipMethods, method)
+}
+
+// matchesMethod reports whether method is one of methods, where an entry
+// ending in a slash, like /grpc.health.v1.Health/, stands for every method
+// of the service
+func matchesMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method || (strings.HasSuffix(m, "/") && strings.HasPrefix(method, m)) {
 			return true
 		}
 	}
-
 	return false
 }
 
@@ -171,6 +189,13 @@ func (ai *AuthInterceptor) validateToken(ctx context.Context) (*TokenClaims, err
 
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 
+	// Static tokens carry no claims besides the user they stand for
+	for staticToken, userID := range ai.config.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(staticToken)) == 1 {
+			return &TokenClaims{UserID: userID, ExpiresAt: time.Now().Add(ai.config.TokenExpiry)}, nil
+		}
+	}
+
 	// Validate token
 	claims, err := ai.parseToken(token)
 	if err != nil {
@@ -203,11 +228,21 @@ func (ai *AuthInterceptor) parseToken(token string) (*TokenClaims, error) {
 		return nil, fmt.Errorf("invalid token format")
 	}
 
+	if ai.config.SecretKey == "" {
+		return nil, fmt.Errorf("JWTs are not accepted")
+	}
+
 	// Decode header and payload
-	_, err := base64.RawURLEncoding.DecodeString(parts[0])
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
 	if err != nil {
 		return nil, fmt.Errorf("invalid token header: %w", err)
 	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil || header.Alg != "HS256" {
+		return nil, fmt.Errorf("invalid token header: only HS256 is supported")
+	}
 
 	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
@@ -217,7 +252,7 @@ func (ai *AuthInterceptor) parseToken(token string) (*TokenClaims, error) {
 	// Verify signature
 	signature := parts[2]
 	expectedSignature := ai.calculateSignature(parts[0] + "." + parts[1])
-	if signature != expectedSignature {
+	if !hmac.Equal([]byte(signature), []byte(expectedSignature)) {
 		return nil, fmt.Errorf("invalid token signature")
 	}
 
@@ -239,30 +274,49 @@ func (ai *AuthInterceptor) calculateSignature(data string) string {
 
 // TokenClaims represents the claims in a token
 type TokenClaims struct {
-	UserID    string    `json:"user_id"`
-	Roles     []string  `json:"roles"`
-	Issuer    string    `json:"iss"`
-	Audience  string    `json:"aud"`
-	IssuedAt  time.Time `json:"iat"`
-	ExpiresAt time.Time `json:"exp"`
+	UserID    string
+	Roles     []string
+	Issuer    string
+	Audience  string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// tokenPayload is the JSON payload of a token; times are Unix seconds
+type tokenPayload struct {
+	UserID   string   `json:"user_id,omitempty"`
+	Subject  string   `json:"sub,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+	Issuer   string   `json:"iss"`
+	Audience string   `json:"aud"`
+	IssuedAt int64    `json:"iat"`
+	Expires  int64    `json:"exp"`
 }
 
 // parseClaims parses claims from JSON payload
 func parseClaims(payload []byte) (*TokenClaims, error) {
-	// Simple JSON parsing - in production, use a proper JSON library
-	// This is a simplified implementation for demonstration
-	_ = payload // TODO: Implement proper JSON parsing
-
-	claims := &TokenClaims{
-		UserID:    "user123",
-		Roles:     []string{"user"},
-		Issuer:    "peervault",
-		Audience:  "peervault-api",
-		IssuedAt:  time.Now().Add(-time.Hour),
-		ExpiresAt: time.Now().Add(24 * time.Hour),
+	var p tokenPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	if p.UserID == "" {
+		p.UserID = p.Subject
+	}
+	if p.UserID == "" {
+		return nil, fmt.Errorf("token names no user")
+	}
+	if p.Expires == 0 {
+		return nil, fmt.Errorf("token has no expiry")
 	}
 
-	return claims, nil
+	return &TokenClaims{
+		UserID:    p.UserID,
+		Roles:     p.Roles,
+		Issuer:    p.Issuer,
+		Audience:  p.Audience,
+		IssuedAt:  time.Unix(p.IssuedAt, 0),
+		ExpiresAt: time.Unix(p.Expires, 0),
+	}, nil
 }
 
 // wrappedServerStream wraps a ServerStream with a custom context
@@ -292,8 +346,18 @@ func (ai *AuthInterceptor) GenerateToken(userID string, roles []string) (string,
 	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
 
 	// Encode payload
-	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"user_id":"%s","roles":["%s"],"iss":"%s","aud":"%s","iat":%d,"exp":%d}`,
-		claims.UserID, strings.Join(claims.Roles, "\",\""), claims.Issuer, claims.Audience, claims.IssuedAt.Unix(), claims.ExpiresAt.Unix())))
+	rawPayload, err := json.Marshal(tokenPayload{
+		UserID:   claims.UserID,
+		Roles:    claims.Roles,
+		Issuer:   claims.Issuer,
+		Audience: claims.Audience,
+		IssuedAt: claims.IssuedAt.Unix(),
+		Expires:  claims.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(rawPayload)
 
 	// Calculate signature
 	signature := ai.calculateSignature(header + "." + payload)
@@ -322,6 +386,11 @@ func (ai *AuthInterceptor) ValidateUserRole(ctx context.Context, requiredRole st
 
 // GetUserID extracts the user ID from the context
 func (ai *AuthInterceptor) GetUserID(ctx context.Context) (string, bool) {
+	return UserIDFromContext(ctx)
+}
+
+// UserIDFromContext returns the user the auth interceptor authenticated
+func UserIDFromContext(ctx context.Context) (string, bool) {
 	userID, ok := ctx.Value(userIDKey).(string)
 	return userID, ok
 }
@@ -3,10 +3,14 @@ package interceptors
 import (
 	"context"
 	"log/slog"
+	"net"
+	"sync"
 	"time"
 
+	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
@@ -63,25 +67,33 @@ func (ci *CustomInterceptor) GetDescription() string {
 	return ci.description
 }
 
-// RateLimitInterceptor provides rate limiting functionality
+// maxRateLimitClients bounds the clients the rate limiter tracks; clients
+// with a full bucket are forgotten first
+const maxRateLimitClients = 10000
+
+// RateLimitInterceptor limits each client, the authenticated user or else
+// the peer address, to a token bucket of requestsPerSecond refilled up to
+// burstSize
 type RateLimitInterceptor struct {
 	*CustomInterceptor
 	requestsPerSecond int
 	burstSize         int
-	requestCounts     map[string]int64
-	lastReset         time.Time
+	limiters          map[string]*rate.Limiter
+	mu                sync.Mutex
 }
 
 // NewRateLimitInterceptor creates a new rate limit interceptor
 func NewRateLimitInterceptor(requestsPerSecond, burstSize int, logger *slog.Logger) *RateLimitInterceptor {
 	base := NewCustomInterceptor("rate_limit", "Rate limiting interceptor", logger)
+	if burstSize < requestsPerSecond {
+		burstSize = requestsPerSecond
+	}
 
 	return &RateLimitInterceptor{
 		CustomInterceptor: base,
 		requestsPerSecond: requestsPerSecond,
 		burstSize:         burstSize,
-		requestCounts:     make(map[string]int64),
-		lastReset:         time.Now(),
+		limiters:          make(map[string]*rate.Limiter),
 	}
 }
 
@@ -105,7 +117,8 @@ func (rli *RateLimitInterceptor) UnaryRateLimitInterceptor() grpc.UnaryServerInt
 	}
 }
 
-// StreamRateLimitInterceptor returns a stream server interceptor for rate limiting
+// StreamRateLimitInterceptor returns a stream server interceptor for rate
+// limiting; a stream counts as one request however many messages it carries
 func (rli *RateLimitInterceptor) StreamRateLimitInterceptor() grpc.StreamServerInterceptor {
 	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 		if !rli.IsEnabled() {
@@ -128,38 +141,47 @@ func (rli *RateLimitInterceptor) StreamRateLimitInterceptor() grpc.StreamServerI
 // getClientID extracts client identifier from context
 func (rli *RateLimitInterceptor) getClientID(ctx context.Context) string {
 	// Try to get user ID first
-	if userID, ok := ctx.Value("user_id").(string); ok {
-		return userID
+	if userID, ok := userIDOf(ctx); ok {
+		return "user:" + userID
 	}
 
-	// Fall back to IP address (would need to be set by another interceptor)
-	if ip, ok := ctx.Value("client_ip").(string); ok {
-		return ip
+	// Fall back to the host of the peer, whatever its port
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+		return "addr:" + host
 	}
 
 	// Default to "unknown"
 	return "unknown"
 }
 
-// checkRateLimit checks if the client is within rate limits
+// checkRateLimit takes a token from the client's bucket
 func (rli *RateLimitInterceptor) checkRateLimit(clientID string) bool {
-	now := time.Now()
+	rli.mu.Lock()
+	defer rli.mu.Unlock()
 
-	// Reset counters if needed
-	if now.Sub(rli.lastReset) >= time.Second {
-		rli.requestCounts = make(map[string]int64)
-		rli.lastReset = now
+	limiter, ok := rli.limiters[clientID]
+	if !ok {
+		if len(rli.limiters) >= maxRateLimitClients {
+			rli.forgetIdleClients()
+		}
+		limiter = rate.NewLimiter(rate.Limit(rli.requestsPerSecond), rli.burstSize)
+		rli.limiters[clientID] = limiter
 	}
+	return limiter.Allow()
+}
 
-	// Check current count
-	currentCount := rli.requestCounts[clientID]
-	if currentCount >= int64(rli.requestsPerSecond) {
-		return false
+// forgetIdleClients drops the clients whose bucket refilled, which a new
+// limiter would recreate exactly
+func (rli *RateLimitInterceptor) forgetIdleClients() {
+	for clientID, limiter := range rli.limiters {
+		if limiter.Tokens() >= float64(rli.burstSize) {
+			delete(rli.limiters, clientID)
+		}
 	}
-
-	// Increment count
-	rli.requestCounts[clientID]++
-	return true
 }
 
 // ValidationInterceptor provides request validation functionality
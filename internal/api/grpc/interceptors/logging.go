@@ -8,6 +8,7 @@ import (
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -36,7 +37,7 @@ func DefaultLoggingConfig() *LoggingConfig {
 		LogMetadata:    false,
 		LogPayload:     false,
 		MaxPayloadSize: 1024,
-		SkipMethods:    []string{"/peervault.PeerVaultService/HealthCheck"},
+		SkipMethods:    []string{"/peervault.PeerVaultService/HealthCheck", "/grpc.health.v1.Health/"},
 		LogLevel:       slog.LevelInfo,
 	}
 }
@@ -71,8 +72,10 @@ func (li *LoggingInterceptor) UnaryLoggingInterceptor() grpc.UnaryServerIntercep
 			return handler(ctx, req)
 		}
 
+		ctx, call := withCallInfo(ctx)
+		requestID := call.requestID
+
 		start := time.Now()
-		requestID := li.generateRequestID()
 
 		// Log request
 		if li.config.LogRequests {
@@ -106,17 +109,20 @@ func (li *LoggingInterceptor) StreamLoggingInterceptor() grpc.StreamServerInterc
 			return handler(srv, ss)
 		}
 
+		ctx, call := withCallInfo(ss.Context())
+		requestID := call.requestID
+
 		start := time.Now()
-		requestID := li.generateRequestID()
 
 		// Log stream start
 		if li.config.LogRequests {
-			li.logStreamStart(ss.Context(), requestID, info.FullMethod)
+			li.logStreamStart(ctx, requestID, info.FullMethod)
 		}
 
 		// Wrap stream for logging
 		wrappedStream := &loggingServerStream{
 			ServerStream: ss,
+			ctx:          ctx,
 			interceptor:  li,
 			requestID:    requestID,
 			method:       info.FullMethod,
@@ -130,12 +136,12 @@ func (li *LoggingInterceptor) StreamLoggingInterceptor() grpc.StreamServerInterc
 
 		// Log stream end
 		if li.config.LogResponses {
-			li.logStreamEnd(ss.Context(), requestID, info.FullMethod, err, duration)
+			li.logStreamEnd(ctx, requestID, info.FullMethod, err, duration)
 		}
 
 		// Log error if present
 		if err != nil && li.config.LogErrors {
-			li.logError(ss.Context(), requestID, info.FullMethod, err, duration)
+			li.logError(ctx, requestID, info.FullMethod, err, duration)
 		}
 
 		return err
@@ -144,12 +150,7 @@ func (li *LoggingInterceptor) StreamLoggingInterceptor() grpc.StreamServerInterc
 
 // shouldSkipLogging checks if the method should skip logging
 func (li *LoggingInterceptor) shouldSkipLogging(method string) bool {
-	for _, skipMethod := range li.config.SkipMethods {
-		if method == skipMethod {
-			return true
-		}
-	}
-	return false
+	return matchesMethod(li.config.SkipMethods, method)
 }
 
 // logRequest logs a request
@@ -162,14 +163,16 @@ func (li *LoggingInterceptor) logRequest(ctx context.Context, requestID, method
 
 	// Add user ID if available
 	if li.config.LogUserID {
-		if userID, ok := ctx.Value("user_id").(string); ok {
+		if userID, ok := userIDOf(ctx); ok {
 			attrs = append(attrs, slog.String("user_id", userID))
 		}
 	}
 
 	// Add metadata if enabled
 	if li.config.LogMetadata {
-		if md, ok := ctx.Value("metadata").(map[string]string); ok {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			md = md.Copy()
+			md.Delete("authorization")
 			attrs = append(attrs, slog.Any("metadata", md))
 		}
 	}
@@ -198,7 +201,7 @@ func (li *LoggingInterceptor) logResponse(ctx context.Context, requestID, method
 
 	// Add user ID if available
 	if li.config.LogUserID {
-		if userID, ok := ctx.Value("user_id").(string); ok {
+		if userID, ok := userIDOf(ctx); ok {
 			attrs = append(attrs, slog.String("user_id", userID))
 		}
 	}
@@ -241,7 +244,7 @@ func (li *LoggingInterceptor) logError(ctx context.Context, requestID, method st
 
 	// Add user ID if available
 	if li.config.LogUserID {
-		if userID, ok := ctx.Value("user_id").(string); ok {
+		if userID, ok := userIDOf(ctx); ok {
 			attrs = append(attrs, slog.String("user_id", userID))
 		}
 	}
@@ -267,7 +270,7 @@ func (li *LoggingInterceptor) logStreamStart(ctx context.Context, requestID, met
 
 	// Add user ID if available
 	if li.config.LogUserID {
-		if userID, ok := ctx.Value("user_id").(string); ok {
+		if userID, ok := userIDOf(ctx); ok {
 			attrs = append(attrs, slog.String("user_id", userID))
 		}
 	}
@@ -290,7 +293,7 @@ func (li *LoggingInterceptor) logStreamEnd(ctx context.Context, requestID, metho
 
 	// Add user ID if available
 	if li.config.LogUserID {
-		if userID, ok := ctx.Value("user_id").(string); ok {
+		if userID, ok := userIDOf(ctx); ok {
 			attrs = append(attrs, slog.String("user_id", userID))
 		}
 	}
@@ -319,14 +322,10 @@ func (li *LoggingInterceptor) truncatePayload(payload string) string {
 	return payload[:li.config.MaxPayloadSize] + "..."
 }
 
-// generateRequestID generates a unique request ID
-func (li *LoggingInterceptor) generateRequestID() string {
-	return fmt.Sprintf("req_%d", time.Now().UnixNano())
-}
-
 // loggingServerStream wraps a ServerStream for logging
 type loggingServerStream struct {
 	grpc.ServerStream
+	ctx          context.Context
 	interceptor  *LoggingInterceptor
 	requestID    string
 	method       string
@@ -334,6 +333,11 @@ type loggingServerStream struct {
 	messageCount int
 }
 
+// Context returns the context carrying the request ID
+func (lss *loggingServerStream) Context() context.Context {
+	return lss.ctx
+}
+
 // SendMsg logs the message being sent
 func (lss *loggingServerStream) SendMsg(m interface{}) error {
 	lss.messageCount++
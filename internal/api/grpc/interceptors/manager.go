@@ -9,6 +9,8 @@ import (
 
 // InterceptorManager manages all gRPC interceptors
 type InterceptorManager struct {
+	requestIDInterceptor      *RequestIDInterceptor
+	recoveryInterceptor       *RecoveryInterceptor
 	authInterceptor           *AuthInterceptor
 	loggingInterceptor        *LoggingInterceptor
 	monitoringInterceptor     *MonitoringInterceptor
@@ -30,6 +32,74 @@ func NewInterceptorManager(logger *slog.Logger) *InterceptorManager {
 	}
 }
 
+// ChainConfig selects the interceptors of a server's chain; nil sections
+// leave their interceptor out
+type ChainConfig struct {
+	// Recovery turns handler panics into INTERNAL errors
+	Recovery bool
+	// Logging logs every call with its request ID
+	Logging *LoggingConfig
+	// Auth requires a static token or a JWT, except for its SkipMethods
+	Auth *AuthConfig
+	// RateLimit limits each client to a token bucket
+	RateLimit *RateLimitConfig
+}
+
+// RateLimitConfig is the token bucket of each client
+type RateLimitConfig struct {
+	RequestsPerSecond int
+	Burst             int
+}
+
+// DefaultChainConfig recovers from panics and logs calls
+func DefaultChainConfig() *ChainConfig {
+	return &ChainConfig{
+		Recovery: true,
+		Logging:  DefaultLoggingConfig(),
+	}
+}
+
+// NewChain creates a manager holding the interceptors config selects
+func NewChain(config *ChainConfig, logger *slog.Logger) *InterceptorManager {
+	if config == nil {
+		config = DefaultChainConfig()
+	}
+
+	im := NewInterceptorManager(logger)
+	im.SetRequestIDInterceptor()
+	if config.Recovery {
+		im.SetRecoveryInterceptor()
+	}
+	if config.Logging != nil {
+		im.SetLoggingInterceptor(config.Logging)
+	}
+	if config.Auth != nil {
+		im.SetAuthInterceptor(config.Auth)
+	}
+	if config.RateLimit != nil {
+		im.SetRateLimitInterceptor(config.RateLimit.RequestsPerSecond, config.RateLimit.Burst)
+	}
+	return im
+}
+
+// ServerOptions returns the options installing the chain on a grpc.Server
+func (im *InterceptorManager) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(im.GetUnaryInterceptors()...),
+		grpc.ChainStreamInterceptor(im.GetStreamInterceptors()...),
+	}
+}
+
+// SetRequestIDInterceptor sets the request ID interceptor
+func (im *InterceptorManager) SetRequestIDInterceptor() {
+	im.requestIDInterceptor = &RequestIDInterceptor{}
+}
+
+// SetRecoveryInterceptor sets the panic recovery interceptor
+func (im *InterceptorManager) SetRecoveryInterceptor() {
+	im.recoveryInterceptor = NewRecoveryInterceptor(im.logger)
+}
+
 // SetAuthInterceptor sets the authentication interceptor
 func (im *InterceptorManager) SetAuthInterceptor(config *AuthConfig) {
 	im.authInterceptor = NewAuthInterceptor(config, im.logger)
@@ -65,23 +135,38 @@ func (im *InterceptorManager) SetCircuitBreakerInterceptor(failureThreshold int,
 	im.circuitBreakerInterceptor = NewCircuitBreakerInterceptor(failureThreshold, timeout, im.logger)
 }
 
-// GetUnaryInterceptors returns all unary server interceptors
+// GetUnaryInterceptors returns all unary server interceptors, in order of
+// execution: request IDs first, then logging, so every outcome is logged
+// with its ID, then recovery, so panics anywhere further in become
+// INTERNAL errors, then auth ahead of rate limiting, which limits
+// authenticated users rather than their addresses
 func (im *InterceptorManager) GetUnaryInterceptors() []grpc.UnaryServerInterceptor {
 	var interceptors []grpc.UnaryServerInterceptor
 
-	// Add interceptors in order of execution
-	if im.circuitBreakerInterceptor != nil {
-		interceptors = append(interceptors, im.circuitBreakerInterceptor.UnaryCircuitBreakerInterceptor())
+	if im.requestIDInterceptor != nil {
+		interceptors = append(interceptors, im.requestIDInterceptor.UnaryRequestIDInterceptor())
 	}
 
-	if im.rateLimitInterceptor != nil {
-		interceptors = append(interceptors, im.rateLimitInterceptor.UnaryRateLimitInterceptor())
+	if im.loggingInterceptor != nil {
+		interceptors = append(interceptors, im.loggingInterceptor.UnaryLoggingInterceptor())
+	}
+
+	if im.recoveryInterceptor != nil {
+		interceptors = append(interceptors, im.recoveryInterceptor.UnaryRecoveryInterceptor())
 	}
 
 	if im.authInterceptor != nil {
 		interceptors = append(interceptors, im.authInterceptor.UnaryAuthInterceptor())
 	}
 
+	if im.rateLimitInterceptor != nil {
+		interceptors = append(interceptors, im.rateLimitInterceptor.UnaryRateLimitInterceptor())
+	}
+
+	if im.circuitBreakerInterceptor != nil {
+		interceptors = append(interceptors, im.circuitBreakerInterceptor.UnaryCircuitBreakerInterceptor())
+	}
+
 	if im.validationInterceptor != nil {
 		interceptors = append(interceptors, im.validationInterceptor.UnaryValidationInterceptor())
 	}
@@ -90,10 +175,6 @@ func (im *InterceptorManager) GetUnaryInterceptors() []grpc.UnaryServerIntercept
 		interceptors = append(interceptors, im.cacheInterceptor.UnaryCacheInterceptor())
 	}
 
-	if im.loggingInterceptor != nil {
-		interceptors = append(interceptors, im.loggingInterceptor.UnaryLoggingInterceptor())
-	}
-
 	if im.monitoringInterceptor != nil {
 		interceptors = append(interceptors, im.monitoringInterceptor.UnaryMonitoringInterceptor())
 	}
@@ -101,29 +182,37 @@ func (im *InterceptorManager) GetUnaryInterceptors() []grpc.UnaryServerIntercept
 	return interceptors
 }
 
-// GetStreamInterceptors returns all stream server interceptors
+// GetStreamInterceptors returns all stream server interceptors, in the
+// order of GetUnaryInterceptors
 func (im *InterceptorManager) GetStreamInterceptors() []grpc.StreamServerInterceptor {
 	var interceptors []grpc.StreamServerInterceptor
 
-	// Add interceptors in order of execution
-	if im.circuitBreakerInterceptor != nil {
-		interceptors = append(interceptors, im.circuitBreakerInterceptor.StreamCircuitBreakerInterceptor())
+	if im.requestIDInterceptor != nil {
+		interceptors = append(interceptors, im.requestIDInterceptor.StreamRequestIDInterceptor())
 	}
 
-	if im.rateLimitInterceptor != nil {
-		interceptors = append(interceptors, im.rateLimitInterceptor.StreamRateLimitInterceptor())
+	if im.loggingInterceptor != nil {
+		interceptors = append(interceptors, im.loggingInterceptor.StreamLoggingInterceptor())
+	}
+
+	if im.recoveryInterceptor != nil {
+		interceptors = append(interceptors, im.recoveryInterceptor.StreamRecoveryInterceptor())
 	}
 
 	if im.authInterceptor != nil {
 		interceptors = append(interceptors, im.authInterceptor.StreamAuthInterceptor())
 	}
 
-	if im.validationInterceptor != nil {
-		interceptors = append(interceptors, im.validationInterceptor.StreamValidationInterceptor())
+	if im.rateLimitInterceptor != nil {
+		interceptors = append(interceptors, im.rateLimitInterceptor.StreamRateLimitInterceptor())
 	}
 
-	if im.loggingInterceptor != nil {
-		interceptors = append(interceptors, im.loggingInterceptor.StreamLoggingInterceptor())
+	if im.circuitBreakerInterceptor != nil {
+		interceptors = append(interceptors, im.circuitBreakerInterceptor.StreamCircuitBreakerInterceptor())
+	}
+
+	if im.validationInterceptor != nil {
+		interceptors = append(interceptors, im.validationInterceptor.StreamValidationInterceptor())
 	}
 
 	if im.monitoringInterceptor != nil {
@@ -240,6 +329,11 @@ func (im *InterceptorManager) DisableInterceptor(interceptorType string) {
 func (im *InterceptorManager) GetInterceptorStatus() map[string]interface{} {
 	status := make(map[string]interface{})
 
+	// Recovery interceptor status
+	status["recovery"] = map[string]interface{}{
+		"enabled": im.recoveryInterceptor != nil,
+	}
+
 	// Auth interceptor status
 	if im.authInterceptor != nil {
 		status["auth"] = map[string]interface{}{
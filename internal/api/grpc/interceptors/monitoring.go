@@ -264,7 +264,7 @@ func (mi *MonitoringInterceptor) startTrace(traceID, method string, ctx context.
 	}
 
 	// Add user ID if available
-	if userID, ok := ctx.Value("user_id").(string); ok {
+	if userID, ok := userIDOf(ctx); ok {
 		trace.UserID = userID
 	}
 
@@ -0,0 +1,64 @@
+package interceptors
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RecoveryInterceptor turns panics in handlers into INTERNAL errors, so a
+// bug fails one call instead of taking the server down
+type RecoveryInterceptor struct {
+	logger *slog.Logger
+}
+
+// NewRecoveryInterceptor creates a new recovery interceptor
+func NewRecoveryInterceptor(logger *slog.Logger) *RecoveryInterceptor {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &RecoveryInterceptor{logger: logger}
+}
+
+// UnaryRecoveryInterceptor returns a unary server interceptor for panic recovery
+func (ri *RecoveryInterceptor) UnaryRecoveryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = ri.recovered(ctx, info.FullMethod, r)
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamRecoveryInterceptor returns a stream server interceptor for panic
+// recovery; the stream ends with INTERNAL rather than being reset
+func (ri *RecoveryInterceptor) StreamRecoveryInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = ri.recovered(ss.Context(), info.FullMethod, r)
+			}
+		}()
+
+		return handler(srv, ss)
+	}
+}
+
+// recovered logs a panic with its stack and returns the error for the client
+func (ri *RecoveryInterceptor) recovered(ctx context.Context, method string, r any) error {
+	attrs := []any{"method", method, "panic", r, "stack", string(debug.Stack())}
+	if requestID, ok := RequestIDFromContext(ctx); ok {
+		attrs = append(attrs, "request_id", requestID)
+	}
+	ri.logger.ErrorContext(ctx, "gRPC handler panicked", attrs...)
+
+	return status.Error(codes.Internal, "internal error")
+}
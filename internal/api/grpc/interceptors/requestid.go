@@ -0,0 +1,91 @@
+package interceptors
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/Skpow1234/Peervault/internal/api/apierror"
+	"github.com/Skpow1234/Peervault/internal/requestid"
+)
+
+// RequestIDHeader is the metadata key carrying request IDs. A client may
+// send one to correlate its logs with the server's; otherwise the server
+// generates one. Either way the server returns it in the response header.
+var RequestIDHeader = strings.ToLower(requestid.Header)
+
+const callInfoKey contextKey = "call_info"
+
+// callInfo is what the chain learns about a call, shared by the
+// interceptors from the outermost one inwards
+type callInfo struct {
+	requestID string
+	userID    string
+}
+
+// withCallInfo returns ctx carrying the call's request ID, taken from the
+// incoming metadata when the client sent a usable one
+func withCallInfo(ctx context.Context) (context.Context, *callInfo) {
+	if info, ok := ctx.Value(callInfoKey).(*callInfo); ok {
+		return ctx, info
+	}
+	info := &callInfo{}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDHeader); len(ids) > 0 && requestid.Valid(ids[0]) {
+			info.requestID = ids[0]
+		}
+	}
+	if info.requestID == "" {
+		info.requestID = requestid.New()
+	}
+	ctx = requestid.NewContext(ctx, info.requestID)
+	return context.WithValue(ctx, callInfoKey, info), info
+}
+
+// RequestIDInterceptor gives every call a request ID, returns it in the
+// response header and reports errors in the shared error model, carrying
+// the ID. It goes first in the chain, so every other interceptor and the
+// handler see the ID in the context.
+type RequestIDInterceptor struct{}
+
+// UnaryRequestIDInterceptor returns a unary server interceptor for request IDs
+func (RequestIDInterceptor) UnaryRequestIDInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, call := withCallInfo(ctx)
+		_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, call.requestID))
+
+		resp, err := handler(ctx, req)
+		return resp, apierror.AnnotateGRPC(err, call.requestID)
+	}
+}
+
+// StreamRequestIDInterceptor returns a stream server interceptor for request IDs
+func (RequestIDInterceptor) StreamRequestIDInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, call := withCallInfo(ss.Context())
+		_ = ss.SetHeader(metadata.Pairs(RequestIDHeader, call.requestID))
+
+		err := handler(srv, &wrappedServerStream{ServerStream: ss, ctx: ctx})
+		return apierror.AnnotateGRPC(err, call.requestID)
+	}
+}
+
+// RequestIDFromContext returns the ID of the call ctx belongs to
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id := requestid.FromContext(ctx)
+	return id, id != ""
+}
+
+// userIDOf returns the authenticated user of the call, also to
+// interceptors outside the auth interceptor
+func userIDOf(ctx context.Context) (string, bool) {
+	if userID, ok := UserIDFromContext(ctx); ok {
+		return userID, true
+	}
+	if info, ok := ctx.Value(callInfoKey).(*callInfo); ok && info.userID != "" {
+		return info.userID, true
+	}
+	return "", false
+}
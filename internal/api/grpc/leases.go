@@ -0,0 +1,84 @@
+package grpc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/consensus"
+)
+
+func (s *Server) handleListLeases(w http.ResponseWriter, r *http.Request) {
+	leases, err := s.config.Cluster.Leases(r.Context())
+	if err != nil {
+		s.writeLeaseError(w, err)
+		return
+	}
+	s.writeJSON(w, map[string]interface{}{"leases": leases, "total": len(leases)})
+}
+
+func (s *Server) handleGetLease(w http.ResponseWriter, r *http.Request) {
+	lease, err := s.config.Cluster.Lease(r.Context(), r.PathValue("name"))
+	if err != nil {
+		s.writeLeaseError(w, err)
+		return
+	}
+	s.writeJSON(w, lease)
+}
+
+func (s *Server) handleAcquireLease(w http.ResponseWriter, r *http.Request) {
+	var req consensus.LeaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Holder == "" || req.TTLSeconds <= 0 {
+		http.Error(w, "holder and a positive ttl_seconds are required", http.StatusBadRequest)
+		return
+	}
+
+	lease, err := s.config.Cluster.AcquireLease(r.Context(), r.PathValue("name"), req)
+	if err != nil {
+		s.writeLeaseError(w, err)
+		return
+	}
+	s.writeJSON(w, lease)
+}
+
+func (s *Server) handleReleaseLease(w http.ResponseWriter, r *http.Request) {
+	holder := r.URL.Query().Get("holder")
+	if holder == "" {
+		http.Error(w, "holder required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.config.Cluster.ReleaseLease(r.Context(), r.PathValue("name"), holder); err != nil {
+		s.writeLeaseError(w, err)
+		return
+	}
+	s.writeJSON(w, map[string]interface{}{"success": true, "message": "Lease released successfully"})
+}
+
+// writeLeaseError answers with the status the REST API uses for the same
+// rejection
+func (s *Server) writeLeaseError(w http.ResponseWriter, err error) {
+	var remote *consensus.RemoteError
+	switch {
+	case errors.Is(err, consensus.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, consensus.ErrLeaseHeld):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.As(err, &remote) && remote.Status == http.StatusBadRequest:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		s.logger.Error("Lease request failed", "error", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	}
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,190 @@
+package grpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/streamlog"
+)
+
+// appendResult is the answer to an append
+type appendResult struct {
+	Records    []streamlog.Record `json:"records"`
+	NextOffset uint64             `json:"next_offset"`
+}
+
+func (s *Server) handleListLogs(w http.ResponseWriter, r *http.Request) {
+	logs, err := s.logs.List(r.Context())
+	if err != nil {
+		s.writeLogError(w, err)
+		return
+	}
+	s.writeJSON(w, map[string]any{"logs": logs, "total": len(logs)})
+}
+
+func (s *Server) handleGetLog(w http.ResponseWriter, r *http.Request) {
+	info, err := s.logs.Info(r.Context(), r.PathValue("name"))
+	if err != nil {
+		s.writeLogError(w, err)
+		return
+	}
+	s.writeJSON(w, info)
+}
+
+// handleAppendRecords appends the body as one record, or each line of an
+// NDJSON body as a record
+func (s *Server) handleAppendRecords(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 16<<20))
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusRequestEntityTooLarge)
+		return
+	}
+	data := [][]byte{body}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-ndjson" {
+		data = data[:0]
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		scanner.Buffer(nil, streamlog.MaxRecordSize+1)
+		for scanner.Scan() {
+			if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+				data = append(data, bytes.Clone(line))
+			}
+		}
+		if scanner.Err() != nil {
+			s.writeLogError(w, streamlog.ErrTooLarge)
+			return
+		}
+	}
+	if len(data) == 0 || len(data[0]) == 0 {
+		http.Error(w, "no records to append", http.StatusBadRequest)
+		return
+	}
+
+	records, err := s.logs.Append(r.Context(), r.PathValue("name"), data...)
+	if err != nil {
+		s.writeLogError(w, err)
+		return
+	}
+	for i := range records {
+		records[i].Data = nil
+	}
+	s.writeJSON(w, appendResult{Records: records, NextOffset: records[len(records)-1].Offset + 1})
+}
+
+// handleStreamRecords streams the records of a log as NDJSON, the way a
+// server-streaming call sends messages. With follow=true the stream stays
+// open and carries records as they are appended.
+func (s *Server) handleStreamRecords(w http.ResponseWriter, r *http.Request) {
+	ctx, name := r.Context(), r.PathValue("name")
+	var q streamlog.Query
+	params := r.URL.Query()
+	if v := params.Get("offset"); v != "" {
+		offset, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+		q.Offset = offset
+	}
+	if v := params.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "invalid since, expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+		q.Since = since
+	}
+	follow := params.Get("follow") == "true"
+	if _, err := s.logs.Info(ctx, name); err != nil {
+		s.writeLogError(w, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	if follow {
+		_ = rc.SetWriteDeadline(time.Time{})
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	for {
+		records, err := s.logs.Read(ctx, name, q)
+		if err != nil {
+			return
+		}
+		for _, rec := range records {
+			if err := enc.Encode(rec); err != nil {
+				return
+			}
+			q.Offset = rec.Offset + 1
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		if len(records) < streamlog.DefaultReadLimit {
+			if !follow {
+				return
+			}
+			if err := s.logs.Wait(ctx, name, q.Offset); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) handleTruncateLog(w http.ResponseWriter, r *http.Request) {
+	var offset uint64
+	var before time.Time
+	if v := r.URL.Query().Get("before_offset"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid before_offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+	if v := r.URL.Query().Get("before_time"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "invalid before_time, expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+		before = t
+	}
+	info, err := s.logs.Truncate(r.Context(), r.PathValue("name"), offset, before)
+	if err != nil {
+		s.writeLogError(w, err)
+		return
+	}
+	s.writeJSON(w, info)
+}
+
+func (s *Server) handleDeleteLog(w http.ResponseWriter, r *http.Request) {
+	if err := s.logs.Delete(r.Context(), r.PathValue("name")); err != nil {
+		s.writeLogError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeLogError answers with the status the REST API uses for the same
+// rejection
+func (s *Server) writeLogError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, streamlog.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, streamlog.ErrInvalidName):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, streamlog.ErrTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	default:
+		s.logger.Error("Log request failed", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,128 @@
+package grpc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/messaging"
+)
+
+// sendMessageRequest is the body of POST /messages; the payload is base64
+// in JSON
+type sendMessageRequest struct {
+	Node       string `json:"node"`
+	Channel    string `json:"channel"`
+	Payload    []byte `json:"payload"`
+	TTLSeconds int64  `json:"ttl_seconds,omitempty"`
+}
+
+// handleSendMessage sends an application message to a channel of a node,
+// answering 202 with its delivery; it waits in the outbox while the node is
+// unreachable
+func (s *Server) handleSendMessage(w http.ResponseWriter, r *http.Request) {
+	var req sendMessageRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*messaging.MaxPayload)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.TTLSeconds < 0 {
+		http.Error(w, "ttl_seconds must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	d, err := s.config.Messaging.Send(req.Node, req.Channel, req.Payload, time.Duration(req.TTLSeconds)*time.Second)
+	if err != nil {
+		s.writeMessageError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(d)
+}
+
+func (s *Server) handleMessageStatus(w http.ResponseWriter, r *http.Request) {
+	d, err := s.config.Messaging.Status(r.PathValue("id"))
+	if err != nil {
+		s.writeMessageError(w, err)
+		return
+	}
+	s.writeJSON(w, d)
+}
+
+// handleReceiveMessages streams the messages queued on the channel
+// parameter as newline-delimited JSON until the client disconnects, the
+// way a server-streaming call sends messages. Each message goes to one
+// stream of its channel.
+func (s *Server) handleReceiveMessages(w http.ResponseWriter, r *http.Request) {
+	ctx, channel := r.Context(), r.URL.Query().Get("channel")
+	if err := messaging.CheckChannel(channel); err != nil {
+		s.writeMessageError(w, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	enc := json.NewEncoder(w)
+	for {
+		m, err := s.config.Messaging.Receive(ctx, channel)
+		if err != nil {
+			return
+		}
+		if err := enc.Encode(m); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// handleStreamReceipts streams the receipts of the messages this node sent
+// as newline-delimited JSON until the client disconnects
+func (s *Server) handleStreamReceipts(w http.ResponseWriter, r *http.Request) {
+	receipts, stop := s.config.Messaging.Receipts(64)
+	defer stop()
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case receipt := <-receipts:
+			if err := enc.Encode(receipt); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) writeMessageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, messaging.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, messaging.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, messaging.ErrQueueFull):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	default:
+		s.logger.Error("Messaging request failed", "error", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	}
+}
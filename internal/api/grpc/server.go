@@ -2,6 +2,8 @@ package grpc
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
@@ -9,8 +11,18 @@ import (
 	"sync"
 	"time"
 
+	"github.com/Skpow1234/Peervault/internal/api/apierror"
+	"github.com/Skpow1234/Peervault/internal/api/grpc/interceptors"
 	"github.com/Skpow1234/Peervault/internal/api/grpc/services"
+	"github.com/Skpow1234/Peervault/internal/consensus"
+	"github.com/Skpow1234/Peervault/internal/etag"
+	"github.com/Skpow1234/Peervault/internal/messaging"
+	"github.com/Skpow1234/Peervault/internal/requestid"
+	"github.com/Skpow1234/Peervault/internal/streamlog"
+	"github.com/Skpow1234/Peervault/internal/tasks"
 	"github.com/Skpow1234/Peervault/proto/peervault"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
 )
 
 // Server represents the gRPC server (simplified for now)
@@ -22,6 +34,13 @@ type Server struct {
 	fileService   *services.FileService
 	peerService   *services.PeerService
 	systemService *services.SystemService
+	// logs are the append-only logs kept among the files
+	logs *streamlog.Manager
+
+	// grpcServer answers the standard health, reflection and channelz
+	// protocols on the same port
+	grpcServer *grpc.Server
+	health     *health.Server
 
 	// Streaming channels for event broadcasting
 	fileEventSubscribers   map[chan *peervault.FileOperationEvent]bool
@@ -38,13 +57,38 @@ type Server struct {
 type Config struct {
 	Port      string
 	AuthToken string
+	// Cluster reaches the Raft group holding the cluster metadata and
+	// serves its locks and leases; nil disables the lease endpoints
+	Cluster *consensus.Client
+	// Messaging is the mailbox of the node applications send messages to
+	// other nodes through and receive theirs from; nil disables the
+	// messaging endpoints
+	Messaging *messaging.Mailbox
+	// Tasks runs the task handlers of the node and calls those of its
+	// peers; nil disables the task endpoints
+	Tasks *tasks.Host
+	// EnableReflection serves gRPC server reflection, for grpcurl and the
+	// like to discover the services
+	EnableReflection bool
+	// EnableChannelz serves channelz, which exposes the connections and
+	// call counts of the server
+	EnableChannelz bool
+	// Interceptors is the chain calls to the gRPC services pass through;
+	// nil recovers from panics and logs calls
+	Interceptors *interceptors.ChainConfig
+	// TLSConfig serves the API over TLS, where gRPC clients negotiate
+	// HTTP/2 with ALPN; nil serves plain HTTP/1.1 and HTTP/2
+	TLSConfig *tls.Config
+	// Logs sets the segment size and retention of append-only logs
+	Logs streamlog.Config
 }
 
 // DefaultConfig returns the default server configuration
 func DefaultConfig() *Config {
 	return &Config{
-		Port:      ":50051",
-		AuthToken: "your-secret-token",
+		Port:             ":50051",
+		AuthToken:        "your-secret-token",
+		EnableReflection: true,
 	}
 }
 
@@ -70,6 +114,8 @@ func NewServer(config *Config, logger *slog.Logger) *Server {
 		startTime:              time.Now(),
 		stopChan:               make(chan struct{}),
 	}
+	server.grpcServer, server.health = newStandardServer(config, logger)
+	server.logs = streamlog.New(config.Logs, server.fileService.LogStore())
 
 	// Create HTTP server with JSON endpoints
 	mux := http.NewServeMux()
@@ -87,6 +133,23 @@ func NewServer(config *Config, logger *slog.Logger) *Server {
 	mux.HandleFunc("GET /files", server.handleListFiles)
 	mux.HandleFunc("GET /files/{key}", server.handleGetFile)
 	mux.HandleFunc("DELETE /files/{key}", server.handleDeleteFile)
+	mux.HandleFunc("GET /watch", server.handleWatch)
+	mux.HandleFunc("POST /batch", server.handleBatch)
+
+	// Directory endpoints
+	mux.HandleFunc("GET /directories/{path...}", server.handleListDirectory)
+	mux.HandleFunc("PUT /directories/{path...}", server.handleMakeDirectory)
+	mux.HandleFunc("DELETE /directories/{path...}", server.handleDeleteDirectory)
+	mux.HandleFunc("POST /directories/copy", server.handleCopyDirectory)
+	mux.HandleFunc("POST /directories/move", server.handleMoveDirectory)
+
+	// Append-only log endpoints; reading records streams them
+	mux.HandleFunc("GET /logs", server.handleListLogs)
+	mux.HandleFunc("GET /logs/{name}", server.handleGetLog)
+	mux.HandleFunc("DELETE /logs/{name}", server.handleDeleteLog)
+	mux.HandleFunc("POST /logs/{name}/records", server.handleAppendRecords)
+	mux.HandleFunc("GET /logs/{name}/records", server.handleStreamRecords)
+	mux.HandleFunc("DELETE /logs/{name}/records", server.handleTruncateLog)
 
 	// Peer operations endpoints
 	mux.HandleFunc("GET /peers", server.handleListPeers)
@@ -95,13 +158,43 @@ func NewServer(config *Config, logger *slog.Logger) *Server {
 	mux.HandleFunc("DELETE /peers/{id}", server.handleRemovePeer)
 	mux.HandleFunc("GET /peers/{id}/health", server.handleGetPeerHealth)
 
+	// Distributed locks and leases endpoints
+	if config.Cluster != nil {
+		mux.HandleFunc("GET /leases", server.handleListLeases)
+		mux.HandleFunc("GET /leases/{name}", server.handleGetLease)
+		mux.HandleFunc("PUT /leases/{name}", server.handleAcquireLease)
+		mux.HandleFunc("DELETE /leases/{name}", server.handleReleaseLease)
+	}
+
+	// Application messaging endpoints; receiving messages and receipts
+	// streams them
+	if config.Messaging != nil {
+		mux.HandleFunc("POST /messages", server.handleSendMessage)
+		mux.HandleFunc("GET /messages", server.handleReceiveMessages)
+		mux.HandleFunc("GET /messages/{id}", server.handleMessageStatus)
+		mux.HandleFunc("GET /receipts", server.handleStreamReceipts)
+	}
+
+	// Task endpoints; calls stream their input and output
+	if config.Tasks != nil {
+		mux.HandleFunc("GET /tasks", server.handleListTasks)
+		mux.HandleFunc("POST /tasks/{node}/{handler}", server.handleInvokeTask)
+	}
+
+	// gRPC clients speak HTTP/2 without TLS, or over it when configured
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(config.TLSConfig != nil)
+	protocols.SetUnencryptedHTTP2(config.TLSConfig == nil)
 	server.httpServer = &http.Server{
 		Addr:              config.Port,
-		Handler:           mux,
+		Handler:           server.withStandardServer(requestid.Middleware(apierror.Middleware(mux))),
+		Protocols:         protocols,
 		ReadHeaderTimeout: 20 * time.Second,
 		ReadTimeout:       30 * time.Second,
 		WriteTimeout:      30 * time.Second,
 		IdleTimeout:       60 * time.Second,
+		TLSConfig:         config.TLSConfig,
 	}
 
 	return server
@@ -113,16 +206,27 @@ func (s *Server) Start() error {
 	if err != nil {
 		return fmt.Errorf("failed to listen on port %s: %w", s.config.Port, err)
 	}
+
+	return s.Serve(listener)
+}
+
+// Serve serves the JSON endpoints and the standard gRPC protocols on
+// listener
+func (s *Server) Serve(listener net.Listener) error {
 	s.listener = listener
 
-	s.logger.Info("Starting gRPC server (HTTP/JSON mode)", "port", s.config.Port)
+	s.logger.Info("Starting gRPC server (HTTP/JSON mode)", "port", s.config.Port, "tls", s.config.TLSConfig != nil)
 
 	// Start event broadcasting goroutines
 	go s.broadcastFileEvents()
 	go s.broadcastPeerEvents()
 	go s.broadcastSystemEvents()
+	s.logs.Start()
 
 	// Start the server
+	if s.config.TLSConfig != nil {
+		return s.httpServer.ServeTLS(listener, "", "")
+	}
 	return s.httpServer.Serve(listener)
 }
 
@@ -132,6 +236,11 @@ func (s *Server) Stop() error {
 
 	// Signal stop to event broadcasting goroutines
 	close(s.stopChan)
+	s.logs.Stop()
+
+	// Tell health watchers before their streams close
+	s.health.Shutdown()
+	s.grpcServer.Stop()
 
 	// Stop the HTTP server
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -229,6 +338,16 @@ func (s *Server) handleGetFile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	w.Header().Set("ETag", etag.Quote(file.Hash))
+	notModified, err := etag.FromRequest(r).CheckRead(file.Hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if _, err := fmt.Fprintf(w, `{"key":"%s","name":"%s","size":%d}`,
@@ -245,9 +364,13 @@ func (s *Server) handleDeleteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	success, err := s.fileService.DeleteFile(key)
+	success, err := s.fileService.DeleteFileIf(key, etag.FromRequest(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		if errors.Is(err, etag.ErrPrecondition) {
+			status = http.StatusPreconditionFailed
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
 
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/batch"
+	"github.com/Skpow1234/Peervault/internal/directory"
+)
+
+// ExecuteBatch runs a validated batch, at most parallelism operations at
+// once, and returns the outcome of each in order. Stores, copies and tags
+// return the *peervault.FileResponse of the file they wrote.
+func (s *FileService) ExecuteBatch(ctx context.Context, ops []batch.Operation, parallelism int) []batch.Outcome {
+	return batch.Run(ctx, ops, parallelism, s.executeBatch)
+}
+
+func (s *FileService) executeBatch(ctx context.Context, op batch.Operation) (any, error) {
+	switch op.Op {
+	case batch.OpStore:
+		data, err := op.Fetch(ctx, http.DefaultClient)
+		if err != nil {
+			return nil, err
+		}
+		return s.UploadFile(op.Key, data)
+	case batch.OpDelete:
+		if _, err := s.DeleteFile(op.Key); err != nil {
+			return nil, fmt.Errorf("%w: %s", directory.ErrNotFound, op.Key)
+		}
+		return nil, nil
+	case batch.OpCopy:
+		if err := (fileDirectoryStore{s}).Copy(ctx, op.From, op.To, op.Overwrite); err != nil {
+			return nil, err
+		}
+		return s.GetFile(op.To)
+	case batch.OpTag:
+		file, err := s.UpdateFileMetadata(op.Key, op.Set)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", directory.ErrNotFound, op.Key)
+		}
+		return file, nil
+	}
+	return nil, fmt.Errorf("%w: unknown op %q", batch.ErrInvalid, op.Op)
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/directory"
+)
+
+// Directories returns the folder view of the stored files
+func (s *FileService) Directories() *directory.Tree {
+	return directory.New(fileDirectoryStore{s})
+}
+
+// fileDirectoryStore adapts FileService to directory.Store. Directory
+// objects are stored as empty files.
+type fileDirectoryStore struct {
+	files *FileService
+}
+
+func (d fileDirectoryStore) List(ctx context.Context, prefix string) ([]directory.Object, error) {
+	s := d.files
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var objects []directory.Object
+	for key, data := range s.files {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		o := directory.Object{Key: key, Name: directory.Base(key), Size: int64(len(data)), ModTime: s.modTimes[key]}
+		if o.IsDir() {
+			o.ContentType = directory.ContentType
+		}
+		objects = append(objects, o)
+	}
+	return objects, nil
+}
+
+func (d fileDirectoryStore) Copy(ctx context.Context, from, to string, overwrite bool) error {
+	s := d.files
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := d.check(from, to, overwrite)
+	if err != nil {
+		return err
+	}
+	s.putLocked(to, bytes.Clone(data), time.Now())
+	return nil
+}
+
+func (d fileDirectoryStore) Rename(ctx context.Context, from, to string, overwrite bool) error {
+	s := d.files
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := d.check(from, to, overwrite)
+	if err != nil {
+		return err
+	}
+	s.putLocked(to, data, s.modTimes[from])
+	s.deleteLocked(from)
+	return nil
+}
+
+// check returns the content of from, which may be written to to. The
+// caller holds the lock.
+func (d fileDirectoryStore) check(from, to string, overwrite bool) ([]byte, error) {
+	data, ok := d.files.files[from]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", directory.ErrNotFound, from)
+	}
+	if _, ok := d.files.files[to]; ok && !overwrite {
+		return nil, fmt.Errorf("%w: %s", directory.ErrExists, to)
+	}
+	return data, nil
+}
+
+func (d fileDirectoryStore) Delete(ctx context.Context, key string) error {
+	_, err := d.files.DeleteFile(key)
+	return err
+}
+
+func (d fileDirectoryStore) Mkdir(ctx context.Context, key string) error {
+	s := d.files
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.files[key]; ok {
+		return fmt.Errorf("%w: %s", directory.ErrExists, key)
+	}
+	s.putLocked(key, []byte{}, time.Now())
+	return nil
+}
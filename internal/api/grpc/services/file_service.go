@@ -3,30 +3,52 @@ package services
 import (
 	"crypto/sha256"
 	"fmt"
+	"sync"
 	"time"
 
 	"google.golang.org/protobuf/types/known/timestamppb"
 
+	"github.com/Skpow1234/Peervault/internal/etag"
+	"github.com/Skpow1234/Peervault/internal/watch"
 	"github.com/Skpow1234/Peervault/proto/peervault"
 )
 
 // FileService provides file-related operations
 type FileService struct {
+	mu sync.RWMutex
 	// Mock storage for demonstration
 	files map[string][]byte
+	// modTimes records when each key was last written
+	modTimes map[string]time.Time
+	// changes journals writes and deletes for watches
+	changes *watch.Journal
 }
 
 // NewFileService creates a new file service instance
 func NewFileService() *FileService {
 	return &FileService{
-		files: make(map[string][]byte),
+		files:    make(map[string][]byte),
+		modTimes: make(map[string]time.Time),
+		changes:  watch.New(0),
 	}
 }
 
 // UploadFile uploads a file and returns file metadata
 func (s *FileService) UploadFile(fileKey string, data []byte) (*peervault.FileResponse, error) {
+	return s.UploadFileIf(fileKey, data, etag.Condition{})
+}
+
+// UploadFileIf uploads a file when the file it replaces meets cond,
+// failing with etag.ErrPrecondition otherwise
+func (s *FileService) UploadFileIf(fileKey string, data []byte, cond etag.Condition) (*peervault.FileResponse, error) {
 	// Store the file data
-	s.files[fileKey] = data
+	s.mu.Lock()
+	if err := s.checkLocked(fileKey, cond); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	s.putLocked(fileKey, data, time.Now())
+	s.mu.Unlock()
 
 	// Calculate hash
 	hash := fmt.Sprintf("%x", sha256.Sum256(data))
@@ -57,6 +79,8 @@ func (s *FileService) UploadFile(fileKey string, data []byte) (*peervault.FileRe
 
 // DownloadFile downloads a file by key
 func (s *FileService) DownloadFile(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	data, exists := s.files[key]
 	if !exists {
 		return nil, fmt.Errorf("file not found: %s", key)
@@ -102,6 +126,8 @@ func (s *FileService) ListFiles(page, pageSize int, filter string) (*peervault.L
 
 // GetFile retrieves file metadata by key
 func (s *FileService) GetFile(key string) (*peervault.FileResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	data, exists := s.files[key]
 	if !exists {
 		return nil, fmt.Errorf("file not found: %s", key)
@@ -124,16 +150,39 @@ func (s *FileService) GetFile(key string) (*peervault.FileResponse, error) {
 
 // DeleteFile deletes a file by key
 func (s *FileService) DeleteFile(key string) (bool, error) {
+	return s.DeleteFileIf(key, etag.Condition{})
+}
+
+// DeleteFileIf deletes a file when it meets cond, failing with
+// etag.ErrPrecondition otherwise
+func (s *FileService) DeleteFileIf(key string, cond etag.Condition) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLocked(key, cond); err != nil {
+		return false, err
+	}
 	if _, exists := s.files[key]; !exists {
 		return false, fmt.Errorf("file not found: %s", key)
 	}
 
-	delete(s.files, key)
+	s.deleteLocked(key)
 	return true, nil
 }
 
+// checkLocked checks cond against the ETag of the file at key, the hash of
+// its content
+func (s *FileService) checkLocked(key string, cond etag.Condition) error {
+	if cond.IsZero() {
+		return nil
+	}
+	data, exists := s.files[key]
+	return cond.Check(fmt.Sprintf("%x", sha256.Sum256(data)), exists)
+}
+
 // UpdateFileMetadata updates file metadata
 func (s *FileService) UpdateFileMetadata(key string, metadata map[string]string) (*peervault.FileResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	data, exists := s.files[key]
 	if !exists {
 		return nil, fmt.Errorf("file not found: %s", key)
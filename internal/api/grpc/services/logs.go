@@ -0,0 +1,55 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/streamlog"
+)
+
+// LogStore keeps the segments of append-only logs among the files
+func (s *FileService) LogStore() streamlog.Store {
+	return fileLogStore{s}
+}
+
+// fileLogStore adapts FileService to streamlog.Store
+type fileLogStore struct {
+	files *FileService
+}
+
+func (l fileLogStore) Has(key string) bool {
+	s := l.files
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.files[key]
+	return ok
+}
+
+func (l fileLogStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s := l.files
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.files[key]
+	if !ok {
+		return nil, fmt.Errorf("file not found: %s", key)
+	}
+	return bytes.Clone(data), nil
+}
+
+func (l fileLogStore) Put(ctx context.Context, key string, data []byte) error {
+	s := l.files
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putLocked(key, bytes.Clone(data), time.Now())
+	return nil
+}
+
+func (l fileLogStore) Delete(ctx context.Context, key string) error {
+	s := l.files
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteLocked(key)
+	return nil
+}
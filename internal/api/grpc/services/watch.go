@@ -0,0 +1,40 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/watch"
+)
+
+// Watch returns the changes to the keys under prefix made after token, see
+// watch.Journal.Watch
+func (s *FileService) Watch(ctx context.Context, prefix, token string) (<-chan watch.Event, error) {
+	return s.changes.Watch(ctx, prefix, token)
+}
+
+// WatchToken returns the token that watches the changes made from now on
+func (s *FileService) WatchToken() string {
+	return s.changes.Token()
+}
+
+// putLocked writes a file and records the change; the caller holds s.mu
+func (s *FileService) putLocked(key string, data []byte, modTime time.Time) {
+	op := watch.Created
+	if _, ok := s.files[key]; ok {
+		op = watch.Updated
+	}
+	s.files[key] = data
+	s.modTimes[key] = modTime
+	s.changes.Record(op, key, int64(len(data)))
+}
+
+// deleteLocked removes a file and records the change; the caller holds s.mu
+func (s *FileService) deleteLocked(key string) {
+	if _, ok := s.files[key]; !ok {
+		return
+	}
+	delete(s.files, key)
+	delete(s.modTimes, key)
+	s.changes.Record(watch.Deleted, key, 0)
+}
@@ -0,0 +1,63 @@
+package grpc
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	channelz "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+
+	"github.com/Skpow1234/Peervault/internal/api/grpc/interceptors"
+)
+
+// newStandardServer creates the gRPC server answering the standard
+// protocols: grpc.health.v1.Health, and when enabled server reflection and
+// channelz. Every registered service, and the server as a whole under the
+// empty name, starts out SERVING. Calls pass through the interceptor chain
+// of config.
+func newStandardServer(config *Config, logger *slog.Logger) (*grpc.Server, *health.Server) {
+	server := grpc.NewServer(interceptors.NewChain(config.Interceptors, logger).ServerOptions()...)
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	if config.EnableReflection {
+		reflection.Register(server)
+	}
+	if config.EnableChannelz {
+		channelz.RegisterChannelzServiceToServer(server)
+	}
+	for name := range server.GetServiceInfo() {
+		healthServer.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+	}
+	return server, healthServer
+}
+
+// withStandardServer sends gRPC requests, HTTP/2 with a gRPC content type,
+// to the standard server and everything else to next
+func (s *Server) withStandardServer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Health watches and reflection streams outlive the JSON timeouts
+		rc := http.NewResponseController(w)
+		_ = rc.SetReadDeadline(time.Time{})
+		_ = rc.SetWriteDeadline(time.Time{})
+		s.grpcServer.ServeHTTP(w, r)
+	})
+}
+
+// SetServingStatus reports whether service is serving to health checks;
+// the empty name stands for the whole server
+func (s *Server) SetServingStatus(service string, serving bool) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	s.health.SetServingStatus(service, status)
+}
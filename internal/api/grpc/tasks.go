@@ -0,0 +1,91 @@
+package grpc
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/tasks"
+)
+
+// TaskErrorTrailer carries the error of task calls that failed after their
+// output started
+const TaskErrorTrailer = "Task-Error"
+
+// handleListTasks lists the task handlers of this node and those its peers
+// advertised
+func (s *Server) handleListTasks(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, map[string]interface{}{
+		"handlers": s.config.Tasks.Handlers(),
+		"peers":    s.config.Tasks.Peers(),
+	})
+}
+
+// handleInvokeTask runs a task handler of a node, the way a bidirectional
+// streaming call would: the request body streams to the handler as its
+// input and its output streams back as the response body. Query parameters
+// are the arguments of the handler. Errors before any output get a status;
+// later ones end the response with the Task-Error trailer.
+func (s *Server) handleInvokeTask(w http.ResponseWriter, r *http.Request) {
+	args := make(map[string]string)
+	for name, values := range r.URL.Query() {
+		args[name] = values[0]
+	}
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+	// The output streams back while the input is still read
+	_ = rc.EnableFullDuplex()
+	w.Header().Set("Trailer", TaskErrorTrailer)
+	out := &taskOutput{w: w, rc: rc}
+	err := s.config.Tasks.Invoke(r.Context(), r.PathValue("node"), r.PathValue("handler"), args, r.Body, out)
+	if err == nil {
+		if !out.started {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.WriteHeader(http.StatusOK)
+		}
+		return
+	}
+	if out.started {
+		w.Header().Set(TaskErrorTrailer, err.Error())
+		return
+	}
+	w.Header().Del("Trailer")
+	switch {
+	case errors.Is(err, tasks.ErrUnknownHandler):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, tasks.ErrDenied):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, tasks.ErrBusy):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, tasks.ErrFailed):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, tasks.ErrUnreachable), errors.Is(err, tasks.ErrDetached):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		s.logger.Error("Task call failed", "error", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+// taskOutput writes the output of a task call to the response, sending
+// the headers with the first bytes
+type taskOutput struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	started bool
+}
+
+func (o *taskOutput) Write(p []byte) (int, error) {
+	if !o.started {
+		o.started = true
+		o.w.Header().Set("Content-Type", "application/octet-stream")
+		o.w.WriteHeader(http.StatusOK)
+	}
+	n, err := o.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, o.rc.Flush()
+}
@@ -0,0 +1,52 @@
+package grpc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/watch"
+)
+
+// handleWatch streams the changes to the keys under the prefix parameter as
+// newline-delimited JSON events until the client disconnects. The token
+// parameter resumes after the event carrying it; without one the watch
+// starts from the token in the X-Watch-Token header. The stream also ends
+// when the client falls behind, and the client resumes it with the token
+// of the last event it read. Tokens the server no longer holds answer 410
+// Gone: the client lists the files again and watches without a token.
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	ctx, params := r.Context(), r.URL.Query()
+	token := params.Get("token")
+	if token == "" {
+		token = s.fileService.WatchToken()
+	}
+	events, err := s.fileService.Watch(ctx, params.Get("prefix"), token)
+	switch {
+	case errors.Is(err, watch.ErrExpired):
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Watch-Token", token)
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	enc := json.NewEncoder(w)
+	for e := range events {
+		if err := enc.Encode(e); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -1,19 +1,16 @@
 package web
 
 import (
-	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
-	"strings"
 
 	"github.com/improbable-eng/grpc-web/go/grpcweb"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
 
 	grpcapi "github.com/Skpow1234/Peervault/internal/api/grpc"
+	"github.com/Skpow1234/Peervault/internal/api/grpc/interceptors"
+	"github.com/Skpow1234/Peervault/internal/api/httpguard"
 	"github.com/Skpow1234/Peervault/proto/peervault"
 )
 
@@ -31,6 +28,8 @@ type Config struct {
 	AllowedOrigins []string
 	CORSEnabled    bool
 	AuthToken      string
+	// Security sets the CSRF protection and security headers of responses
+	Security httpguard.Policy
 }
 
 // DefaultConfig returns the default gRPC-Web server configuration
@@ -45,6 +44,7 @@ func DefaultConfig() *Config {
 		},
 		CORSEnabled: true,
 		AuthToken:   "your-secret-token",
+		Security:    httpguard.DefaultPolicy(),
 	}
 }
 
@@ -58,11 +58,13 @@ func NewWebServer(config *Config, logger *slog.Logger) *WebServer {
 		logger = slog.Default()
 	}
 
-	// Create gRPC server with interceptors
-	grpcServer := grpc.NewServer(
-		grpc.UnaryInterceptor(unaryAuthInterceptor(config.AuthToken)),
-		grpc.StreamInterceptor(streamAuthInterceptor(config.AuthToken)),
-	)
+	// Create gRPC server with interceptors: the auth token is the only
+	// credential, JWTs are not accepted
+	chain := interceptors.DefaultChainConfig()
+	chain.Auth = interceptors.DefaultAuthConfig()
+	chain.Auth.SecretKey = ""
+	chain.Auth.Tokens = map[string]string{config.AuthToken: "grpc-web"}
+	grpcServer := grpc.NewServer(interceptors.NewChain(chain, logger).ServerOptions()...)
 
 	// Register services
 	peervault.RegisterPeerVaultServiceServer(grpcServer, &grpcapi.PeerVaultServiceImpl{})
@@ -117,45 +119,18 @@ func (s *WebServer) createHTTPHandler() http.Handler {
 	// gRPC-Web endpoint
 	mux.Handle("/", s.webServer)
 
-	// Add CORS middleware if enabled
+	// CORS, CSRF protection and security headers; with CORS disabled no
+	// other origin is allowed
+	config := &httpguard.Config{
+		Policy:         s.config.Security,
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Content-Type", "Authorization", "X-Grpc-Web", "X-User-Agent", "Grpc-Timeout"},
+		ExposedHeaders: []string{"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin"},
+	}
 	if s.config.CORSEnabled {
-		return s.corsMiddleware(mux)
+		config.AllowedOrigins = s.config.AllowedOrigins
 	}
-
-	return mux
-}
-
-// corsMiddleware adds CORS headers to responses
-func (s *WebServer) corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
-
-		// Check if origin is allowed
-		allowed := false
-		for _, allowedOrigin := range s.config.AllowedOrigins {
-			if origin == allowedOrigin {
-				allowed = true
-				break
-			}
-		}
-
-		if allowed {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-		}
-
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Grpc-Web, X-User-Agent")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Max-Age", "86400")
-
-		// Handle preflight requests
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
+	return httpguard.Middleware(config, mux)
 }
 
 // handleHealthCheck handles health check requests
@@ -167,51 +142,3 @@ func (s *WebServer) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 		s.logger.Warn("Failed to write health check response", "error", err)
 	}
 }
-
-// unaryAuthInterceptor provides authentication for unary RPC calls
-func unaryAuthInterceptor(authToken string) grpc.UnaryServerInterceptor {
-	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		// Extract token from metadata
-		md, ok := metadata.FromIncomingContext(ctx)
-		if !ok {
-			return nil, status.Error(codes.Unauthenticated, "missing metadata")
-		}
-
-		authHeader := md.Get("authorization")
-		if len(authHeader) == 0 {
-			return nil, status.Error(codes.Unauthenticated, "missing authorization header")
-		}
-
-		// Check if token matches
-		token := strings.TrimPrefix(authHeader[0], "Bearer ")
-		if token != authToken {
-			return nil, status.Error(codes.Unauthenticated, "invalid token")
-		}
-
-		return handler(ctx, req)
-	}
-}
-
-// streamAuthInterceptor provides authentication for streaming RPC calls
-func streamAuthInterceptor(authToken string) grpc.StreamServerInterceptor {
-	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-		// Extract token from metadata
-		md, ok := metadata.FromIncomingContext(ss.Context())
-		if !ok {
-			return status.Error(codes.Unauthenticated, "missing metadata")
-		}
-
-		authHeader := md.Get("authorization")
-		if len(authHeader) == 0 {
-			return status.Error(codes.Unauthenticated, "missing authorization header")
-		}
-
-		// Check if token matches
-		token := strings.TrimPrefix(authHeader[0], "Bearer ")
-		if token != authToken {
-			return status.Error(codes.Unauthenticated, "invalid token")
-		}
-
-		return handler(srv, ss)
-	}
-}
@@ -0,0 +1,212 @@
+// Package httpguard is the browser-facing protection every PeerVault HTTP
+// API shares: CORS with proper preflight handling, CSRF protection for
+// state-changing requests and security headers.
+package httpguard
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is the part of Config operators set once for all HTTP APIs
+type Policy struct {
+	// CSRF rejects state-changing requests a browser could send on a
+	// user's behalf from another site; see Middleware
+	CSRF bool
+	// HSTSMaxAge is announced in Strict-Transport-Security on requests
+	// that came over TLS; 0 sends no header
+	HSTSMaxAge time.Duration
+	// ContentSecurityPolicy is sent with every response; handlers serving
+	// pages that need more, such as the API playgrounds, set their own
+	ContentSecurityPolicy string
+	// PreflightMaxAge is how long browsers may cache preflight results
+	PreflightMaxAge time.Duration
+}
+
+// DefaultContentSecurityPolicy lets API responses load nothing and be
+// framed nowhere
+const DefaultContentSecurityPolicy = "default-src 'none'; frame-ancestors 'none'"
+
+// DefaultPolicy returns the default policy
+func DefaultPolicy() Policy {
+	return Policy{
+		CSRF:                  true,
+		HSTSMaxAge:            365 * 24 * time.Hour,
+		ContentSecurityPolicy: DefaultContentSecurityPolicy,
+		PreflightMaxAge:       10 * time.Minute,
+	}
+}
+
+// Config configures Middleware for one server
+type Config struct {
+	Policy
+	// AllowedOrigins may make cross-origin requests. "*" allows any
+	// origin, but only origins listed by name get credentials and pass
+	// the CSRF check.
+	AllowedOrigins []string
+	// AllowedMethods may be used cross-origin
+	AllowedMethods []string
+	// AllowedHeaders may be sent cross-origin; "*" allows any
+	AllowedHeaders []string
+	// ExposedHeaders may be read by cross-origin scripts
+	ExposedHeaders []string
+}
+
+// Middleware protects next according to config:
+//
+//   - CORS: responses to allowed origins carry Access-Control-Allow-Origin,
+//     and preflight requests are answered here, 403 Forbidden when the
+//     origin, method or a header is not allowed.
+//   - CSRF: POST, PUT, PATCH and DELETE requests from another origin are
+//     rejected with 403 Forbidden unless the origin is listed by name or
+//     the request carries an Authorization header, which browsers never
+//     add on their own. Requests without Origin or Sec-Fetch-Site headers
+//     come from outside browsers and pass. WebSocket upgrades, which
+//     browsers do not subject to CORS, must come from an allowed origin.
+//   - Headers: X-Content-Type-Options, X-Frame-Options, Referrer-Policy,
+//     the Content-Security-Policy and, over TLS, Strict-Transport-Security.
+func Middleware(config *Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setSecurityHeaders(w, r, config)
+
+		origin := r.Header.Get("Origin")
+		if origin != "" && !sameOrigin(r, origin) {
+			allowed, named := config.allowOrigin(origin)
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				config.preflight(w, r, origin, allowed, named)
+				return
+			}
+			if allowed {
+				w.Header().Add("Vary", "Origin")
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				if named {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+				if len(config.ExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(config.ExposedHeaders, ", "))
+				}
+			}
+			if isWebSocket(r) && !allowed {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+		}
+
+		if config.CSRF && !config.csrfSafe(r) {
+			http.Error(w, "Cross-origin request rejected", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// preflight answers a CORS preflight request
+func (c *Config) preflight(w http.ResponseWriter, r *http.Request, origin string, allowed, named bool) {
+	w.Header().Add("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+	if !allowed {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !contains(c.AllowedMethods, method, false) {
+		http.Error(w, "Method not allowed", http.StatusForbidden)
+		return
+	}
+	var headers []string
+	for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		if !contains(c.AllowedHeaders, "*", false) && !contains(c.AllowedHeaders, h, true) {
+			http.Error(w, "Header not allowed: "+h, http.StatusForbidden)
+			return
+		}
+		headers = append(headers, h)
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if named {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+	if len(headers) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if c.PreflightMaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.PreflightMaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// allowOrigin reports whether origin is allowed, and whether by name
+func (c *Config) allowOrigin(origin string) (allowed, named bool) {
+	for _, o := range c.AllowedOrigins {
+		if strings.EqualFold(o, origin) {
+			return true, true
+		}
+		if o == "*" {
+			allowed = true
+		}
+	}
+	return allowed, false
+}
+
+// csrfSafe reports whether r cannot be a forged cross-site request
+func (c *Config) csrfSafe(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	if r.Header.Get("Authorization") != "" {
+		return true
+	}
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return r.Header.Get("Sec-Fetch-Site") == ""
+	}
+	if sameOrigin(r, origin) {
+		return true
+	}
+	_, named := c.allowOrigin(origin)
+	return named
+}
+
+// setSecurityHeaders sets the security headers of every response
+func setSecurityHeaders(w http.ResponseWriter, r *http.Request, config *Config) {
+	h := w.Header()
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("X-Frame-Options", "DENY")
+	h.Set("Referrer-Policy", "no-referrer")
+	if config.ContentSecurityPolicy != "" {
+		h.Set("Content-Security-Policy", config.ContentSecurityPolicy)
+	}
+	if config.HSTSMaxAge > 0 && (r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https") {
+		h.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(config.HSTSMaxAge.Seconds()))+"; includeSubDomains")
+	}
+}
+
+// sameOrigin reports whether origin is the host r was sent to
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+func isWebSocket(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+func contains(list []string, s string, fold bool) bool {
+	for _, v := range list {
+		if v == s || (fold && strings.EqualFold(v, s)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package httpguard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testHandler(origins ...string) http.Handler {
+	return Middleware(&Config{
+		Policy:         DefaultPolicy(),
+		AllowedOrigins: origins,
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
+		ExposedHeaders: []string{"X-Request-ID"},
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+}
+
+func serve(h http.Handler, method, origin string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "http://api.example.com/files", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestPreflight(t *testing.T) {
+	h := testHandler("https://app.example.com")
+
+	w := serve(h, "OPTIONS", "https://app.example.com", map[string]string{
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "content-type, authorization",
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type, authorization", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+
+	for name, header := range map[string]map[string]string{
+		"method": {"Access-Control-Request-Method": "DELETE"},
+		"header": {"Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "X-Secret"},
+	} {
+		w = serve(h, "OPTIONS", "https://app.example.com", header)
+		assert.Equal(t, http.StatusForbidden, w.Code, name)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), name)
+	}
+
+	w = serve(h, "OPTIONS", "https://evil.example", map[string]string{"Access-Control-Request-Method": "GET"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestWildcardOrigin(t *testing.T) {
+	h := testHandler("*")
+
+	w := serve(h, "GET", "https://any.example", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://any.example", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "X-Request-ID", w.Header().Get("Access-Control-Expose-Headers"))
+
+	// Without credentials of its own a cross-origin POST could be forged
+	w = serve(h, "POST", "https://any.example", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = serve(h, "POST", "https://any.example", map[string]string{"Authorization": "Bearer token"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestCSRF(t *testing.T) {
+	h := testHandler("https://app.example.com")
+
+	for name, tc := range map[string]struct {
+		origin string
+		header map[string]string
+		status int
+	}{
+		"no browser headers": {status: http.StatusOK},
+		"same origin":        {origin: "http://api.example.com", status: http.StatusOK},
+		"listed origin":      {origin: "https://app.example.com", status: http.StatusOK},
+		"other origin":       {origin: "https://evil.example", status: http.StatusForbidden},
+		"fetch same origin":  {header: map[string]string{"Sec-Fetch-Site": "same-origin"}, status: http.StatusOK},
+		"fetch cross site":   {header: map[string]string{"Sec-Fetch-Site": "cross-site"}, status: http.StatusForbidden},
+		"authorized":         {origin: "https://evil.example", header: map[string]string{"Authorization": "Bearer token"}, status: http.StatusOK},
+	} {
+		w := serve(h, "POST", tc.origin, tc.header)
+		assert.Equal(t, tc.status, w.Code, name)
+	}
+
+	// Safe methods are never rejected
+	assert.Equal(t, http.StatusOK, serve(h, "GET", "https://evil.example", nil).Code)
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	upgrade := map[string]string{"Connection": "Upgrade", "Upgrade": "websocket"}
+	assert.Equal(t, http.StatusForbidden, serve(testHandler("https://app.example.com"), "GET", "https://evil.example", upgrade).Code)
+	assert.Equal(t, http.StatusOK, serve(testHandler("https://app.example.com"), "GET", "https://app.example.com", upgrade).Code)
+	assert.Equal(t, http.StatusOK, serve(testHandler("*"), "GET", "https://evil.example", upgrade).Code)
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	h := testHandler()
+
+	w := serve(h, "GET", "", nil)
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "no-referrer", w.Header().Get("Referrer-Policy"))
+	assert.Equal(t, DefaultContentSecurityPolicy, w.Header().Get("Content-Security-Policy"))
+	assert.Empty(t, w.Header().Get("Strict-Transport-Security"))
+
+	w = serve(h, "GET", "", map[string]string{"X-Forwarded-Proto": "https"})
+	assert.Equal(t, "max-age=31536000; includeSubDomains", w.Header().Get("Strict-Transport-Security"))
+}
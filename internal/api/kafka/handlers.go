@@ -0,0 +1,711 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/pubsub"
+)
+
+const (
+	// fetchBatch is the most messages a fetch reads from a log at once
+	fetchBatch = 500
+	// recordOverhead estimates the bytes a record adds to its value
+	recordOverhead = 16
+
+	// Special timestamps of ListOffsets
+	latestTimestamp   = -1
+	earliestTimestamp = -2
+)
+
+// durableTopic returns the durable topic of a Kafka topic. Kafka topics
+// separate levels with dots where durable topics use slashes.
+func (c *conn) durableTopic(name string) (string, bool) {
+	topic := c.server.config.Prefix + strings.ReplaceAll(name, ".", "/")
+	return topic, name != "" && c.topics.Durable(topic)
+}
+
+// kafkaTopic returns the Kafka topic of a durable topic, false for topics
+// outside the prefix
+func (c *conn) kafkaTopic(topic string) (string, bool) {
+	rest, ok := strings.CutPrefix(topic, c.server.config.Prefix)
+	if !ok || rest == "" {
+		return "", false
+	}
+	return strings.ReplaceAll(rest, "/", "."), true
+}
+
+// partitionError returns the error code of a topic and partition no
+// request can use, errNone for the partition of a durable topic
+func (c *conn) partitionError(name string, partition int32) (string, int16) {
+	topic, ok := c.durableTopic(name)
+	switch {
+	case !ok:
+		return "", errInvalidTopic
+	case partition != 0:
+		return "", errUnknownTopicOrPartition
+	}
+	return topic, errNone
+}
+
+// offsets returns the oldest offset of a topic and the one of its next
+// message
+func (c *conn) offsets(ctx context.Context, topic string) (start, next int64, err error) {
+	info, err := c.topics.Info(ctx, topic)
+	if errors.Is(err, pubsub.ErrNotFound) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return int64(info.StartOffset), int64(info.NextOffset), nil
+}
+
+// serverError logs an error the client only sees the code of
+func (c *conn) serverError(msg, topic string, err error) int16 {
+	c.server.logger.Error(msg, "error", err, "topic", topic)
+	return errUnknownServerError
+}
+
+func (c *conn) apiVersions(version, code int16) []byte {
+	e := &encoder{}
+	e.int16(code)
+	e.arrayLen(len(supported))
+	for _, v := range supported {
+		e.int16(v.key)
+		e.int16(v.min)
+		e.int16(v.max)
+	}
+	if version >= 1 {
+		e.int32(0) // throttle time
+	}
+	return e.buf
+}
+
+// metadata describes the broker and the topics asked for. Every Kafka
+// topic that names a durable topic exists, with one partition, so
+// producers create topics by producing to them.
+func (c *conn) metadata(ctx context.Context, req *request) ([]byte, error) {
+	d, v := req.body, req.version
+	n := d.arrayLen()
+	names := make([]string, 0, max(n, 0))
+	for range n {
+		names = append(names, d.string())
+	}
+	if v >= 4 {
+		d.bool() // allow auto topic creation
+	}
+	if v >= 8 {
+		d.bool() // include cluster authorized operations
+		d.bool() // include topic authorized operations
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	// All topics are asked for with null, or no topics in version 0
+	if n < 0 || (n == 0 && v == 0) {
+		infos, err := c.topics.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if name, ok := c.kafkaTopic(info.Topic); ok {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+	}
+
+	e := &encoder{}
+	if v >= 3 {
+		e.int32(0) // throttle time
+	}
+	e.arrayLen(1)
+	e.int32(nodeID)
+	e.string(c.host)
+	e.int32(c.port)
+	if v >= 1 {
+		e.nullableString("") // rack
+	}
+	if v >= 2 {
+		e.nullableString(c.server.config.ClusterID)
+	}
+	if v >= 1 {
+		e.int32(nodeID) // controller
+	}
+	e.arrayLen(len(names))
+	for _, name := range names {
+		_, code := c.partitionError(name, 0)
+		e.int16(code)
+		e.string(name)
+		if v >= 1 {
+			e.bool(false) // internal
+		}
+		if code != errNone {
+			e.arrayLen(0)
+		} else {
+			e.arrayLen(1)
+			e.int16(errNone)
+			e.int32(0)
+			e.int32(nodeID)
+			if v >= 7 {
+				e.int32(-1) // leader epoch
+			}
+			e.int32s(nodeID) // replicas
+			e.int32s(nodeID) // in-sync replicas
+			if v >= 5 {
+				e.int32s() // offline replicas
+			}
+		}
+		if v >= 8 {
+			e.int32(noAuthorizedOperations)
+		}
+	}
+	if v >= 8 {
+		e.int32(noAuthorizedOperations)
+	}
+	return e.buf, nil
+}
+
+type producePartition struct {
+	index   int32
+	records []byte
+}
+
+type produceTopic struct {
+	name       string
+	partitions []producePartition
+}
+
+// produce appends the records of each partition to its durable topic.
+// Requests with acks 0 get no response.
+func (c *conn) produce(ctx context.Context, req *request) ([]byte, error) {
+	d, v := req.body, req.version
+	d.nullableString() // transactional ID
+	acks := d.int16()
+	d.int32() // timeout
+	n := d.arrayLen()
+	topics := make([]produceTopic, 0, max(n, 0))
+	for range n {
+		t := produceTopic{name: d.string()}
+		for range d.arrayLen() {
+			t.partitions = append(t.partitions, producePartition{index: d.int32(), records: d.bytes()})
+		}
+		topics = append(topics, t)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	e := &encoder{}
+	e.arrayLen(len(topics))
+	for _, t := range topics {
+		e.string(t.name)
+		e.arrayLen(len(t.partitions))
+		for _, p := range t.partitions {
+			code, message := errInvalidRequiredAcks, ""
+			base, start := int64(-1), int64(-1)
+			if acks == -1 || acks == 0 || acks == 1 {
+				base, start, code, message = c.append(ctx, t.name, p)
+			}
+			e.int32(p.index)
+			e.int16(code)
+			e.int64(base)
+			e.int64(-1) // log append time, not used
+			if v >= 5 {
+				e.int64(start)
+			}
+			if v >= 8 {
+				e.arrayLen(0) // record errors
+				e.nullableString(message)
+			}
+		}
+	}
+	e.int32(0) // throttle time
+	if acks == 0 {
+		return nil, nil
+	}
+	return e.buf, nil
+}
+
+// append publishes the records of a partition, returning the offset of the
+// first and the oldest offset of the topic
+func (c *conn) append(ctx context.Context, name string, p producePartition) (base, start int64, code int16, message string) {
+	topic, code := c.partitionError(name, p.index)
+	if code != errNone {
+		return -1, -1, code, ""
+	}
+	values, err := decodeBatches(p.records)
+	if err != nil {
+		return -1, -1, produceError(err), err.Error()
+	}
+	base = -1
+	if len(values) > 0 {
+		messages, err := c.topics.PublishBatch(ctx, topic, values...)
+		if err != nil {
+			if code = produceError(err); code == errUnknownServerError {
+				c.serverError("Failed to append Kafka records", topic, err)
+			}
+			return -1, -1, code, ""
+		}
+		base = int64(messages[0].Offset)
+	}
+	start, next, err := c.offsets(ctx, topic)
+	if err != nil {
+		return -1, -1, c.serverError("Failed to describe durable topic", topic, err), ""
+	}
+	if base < 0 {
+		base = next
+	}
+	return base, start, errNone, ""
+}
+
+type fetchPartition struct {
+	index    int32
+	offset   int64
+	maxBytes int32
+
+	topic     string
+	code      int16
+	watermark int64
+	start     int64
+	records   []byte
+}
+
+type fetchTopic struct {
+	name       string
+	partitions []*fetchPartition
+}
+
+// fetch returns the messages of each partition from its offset on. When
+// there are fewer than the minimum bytes it waits up to the maximum wait
+// time for more. Fetch sessions are not kept: clients that ask for one get
+// session 0 and send full requests.
+func (c *conn) fetch(ctx context.Context, req *request) ([]byte, error) {
+	d, v := req.body, req.version
+	d.int32() // replica ID
+	maxWait := time.Duration(d.int32()) * time.Millisecond
+	minBytes := int(d.int32())
+	maxBytes := int(d.int32())
+	d.int8() // isolation level
+	var session int32
+	if v >= 7 {
+		session = d.int32()
+		d.int32() // session epoch
+	}
+	n := d.arrayLen()
+	topics := make([]fetchTopic, 0, max(n, 0))
+	for range n {
+		t := fetchTopic{name: d.string()}
+		for range d.arrayLen() {
+			p := &fetchPartition{index: d.int32()}
+			if v >= 9 {
+				d.int32() // current leader epoch
+			}
+			p.offset = d.int64()
+			if v >= 5 {
+				d.int64() // log start offset
+			}
+			p.maxBytes = d.int32()
+			t.partitions = append(t.partitions, p)
+		}
+		topics = append(topics, t)
+	}
+	if v >= 7 {
+		for range d.arrayLen() {
+			d.string()
+			for range d.arrayLen() {
+				d.int32()
+			}
+		}
+	}
+	if v >= 11 {
+		d.string() // rack ID
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	code := errNone
+	if session != 0 {
+		code, topics = errFetchSessionIDNotFound, nil
+	}
+	read, failed := c.read(ctx, topics, maxBytes)
+	if read < minBytes && !failed && maxWait > 0 {
+		c.wait(ctx, topics, maxWait)
+		c.read(ctx, topics, maxBytes)
+	}
+
+	e := &encoder{}
+	e.int32(0) // throttle time
+	if v >= 7 {
+		e.int16(code)
+		e.int32(0) // session ID
+	}
+	e.arrayLen(len(topics))
+	for _, t := range topics {
+		e.string(t.name)
+		e.arrayLen(len(t.partitions))
+		for _, p := range t.partitions {
+			e.int32(p.index)
+			e.int16(p.code)
+			e.int64(p.watermark)
+			e.int64(p.watermark) // last stable offset
+			if v >= 5 {
+				e.int64(p.start)
+			}
+			e.arrayLen(0) // aborted transactions
+			if v >= 11 {
+				e.int32(-1) // preferred read replica
+			}
+			if p.records == nil {
+				p.records = []byte{}
+			}
+			e.bytes(p.records)
+		}
+	}
+	return e.buf, nil
+}
+
+// read reads the messages of each partition up to maxBytes, at least one
+// while any bytes are left. It returns the bytes read and whether a
+// partition failed.
+func (c *conn) read(ctx context.Context, topics []fetchTopic, maxBytes int) (int, bool) {
+	if maxBytes <= 0 {
+		maxBytes = maxRequestSize
+	}
+	read, failed := 0, false
+	for _, t := range topics {
+		for _, p := range t.partitions {
+			p.records = nil
+			if p.topic, p.code = c.partitionError(t.name, p.index); p.code != errNone {
+				failed = true
+				continue
+			}
+			start, next, err := c.offsets(ctx, p.topic)
+			if err != nil {
+				p.code, failed = c.serverError("Failed to describe durable topic", p.topic, err), true
+				continue
+			}
+			p.start, p.watermark = start, next
+			if p.offset < start || p.offset > next {
+				p.code, failed = errOffsetOutOfRange, true
+				continue
+			}
+			if p.offset == next || read >= maxBytes {
+				continue
+			}
+			messages, err := c.topics.Read(ctx, p.topic, uint64(p.offset), fetchBatch)
+			if err != nil {
+				p.code, failed = c.serverError("Failed to read durable topic", p.topic, err), true
+				continue
+			}
+			limit, size, keep := min(int(p.maxBytes), maxBytes-read), 0, 0
+			for i, m := range messages {
+				size += len(m.Payload) + recordOverhead
+				if i > 0 && size > limit {
+					break
+				}
+				keep = i + 1
+			}
+			p.records = encodeBatch(messages[:keep])
+			read += len(p.records)
+		}
+	}
+	return read, failed
+}
+
+// wait returns once a message is published to one of the partitions, or
+// after maxWait
+func (c *conn) wait(ctx context.Context, topics []fetchTopic, maxWait time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, maxWait)
+	defer cancel()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, t := range topics {
+		for _, p := range t.partitions {
+			sub, err := c.topics.Subscribe(ctx, p.topic, "", p.offset)
+			if err != nil {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := sub.Next(ctx, 1); err == nil {
+					cancel()
+				}
+			}()
+		}
+	}
+	<-ctx.Done()
+}
+
+// listOffsets finds the offset of each partition at a timestamp: the
+// next offset for -1, the oldest for -2 and otherwise the first message
+// published at or after it
+func (c *conn) listOffsets(ctx context.Context, req *request) ([]byte, error) {
+	d, v := req.body, req.version
+	type partition struct {
+		index     int32
+		timestamp int64
+	}
+	type topic struct {
+		name       string
+		partitions []partition
+	}
+	d.int32() // replica ID
+	if v >= 2 {
+		d.int8() // isolation level
+	}
+	n := d.arrayLen()
+	topics := make([]topic, 0, max(n, 0))
+	for range n {
+		t := topic{name: d.string()}
+		for range d.arrayLen() {
+			p := partition{index: d.int32()}
+			if v >= 4 {
+				d.int32() // current leader epoch
+			}
+			p.timestamp = d.int64()
+			t.partitions = append(t.partitions, p)
+		}
+		topics = append(topics, t)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	e := &encoder{}
+	if v >= 2 {
+		e.int32(0) // throttle time
+	}
+	e.arrayLen(len(topics))
+	for _, t := range topics {
+		e.string(t.name)
+		e.arrayLen(len(t.partitions))
+		for _, p := range t.partitions {
+			timestamp, offset, code := c.offsetAt(ctx, t.name, p.index, p.timestamp)
+			e.int32(p.index)
+			e.int16(code)
+			e.int64(timestamp)
+			e.int64(offset)
+			if v >= 4 {
+				e.int32(-1) // leader epoch
+			}
+		}
+	}
+	return e.buf, nil
+}
+
+func (c *conn) offsetAt(ctx context.Context, name string, partition int32, timestamp int64) (int64, int64, int16) {
+	topic, code := c.partitionError(name, partition)
+	if code != errNone {
+		return -1, -1, code
+	}
+	switch timestamp {
+	case latestTimestamp, earliestTimestamp:
+		start, next, err := c.offsets(ctx, topic)
+		if err != nil {
+			return -1, -1, c.serverError("Failed to describe durable topic", topic, err)
+		}
+		if timestamp == latestTimestamp {
+			return -1, next, errNone
+		}
+		return -1, start, errNone
+	}
+	m, ok, err := c.topics.MessageAt(ctx, topic, time.UnixMilli(timestamp))
+	if err != nil {
+		return -1, -1, c.serverError("Failed to read durable topic", topic, err)
+	}
+	if !ok {
+		return -1, -1, errNone
+	}
+	return m.Time.UnixMilli(), int64(m.Offset), errNone
+}
+
+// findCoordinator names the broker as the coordinator of every group.
+// Transactions have none.
+func (c *conn) findCoordinator(req *request) ([]byte, error) {
+	d, v := req.body, req.version
+	d.string() // key
+	var keyType int8
+	if v >= 1 {
+		keyType = d.int8()
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	e := &encoder{}
+	if v >= 1 {
+		e.int32(0) // throttle time
+	}
+	if keyType != 0 {
+		e.int16(errCoordinatorNotAvailable)
+		if v >= 1 {
+			e.nullableString("transactions are not supported")
+		}
+		e.int32(-1)
+		e.string("")
+		e.int32(-1)
+		return e.buf, nil
+	}
+	e.int16(errNone)
+	if v >= 1 {
+		e.nullableString("")
+	}
+	e.int32(nodeID)
+	e.string(c.host)
+	e.int32(c.port)
+	return e.buf, nil
+}
+
+// offsetCommit sets where groups resume partitions. Generations and
+// members are not checked, as groups have no membership, and metadata is
+// not kept.
+func (c *conn) offsetCommit(ctx context.Context, req *request) ([]byte, error) {
+	d, v := req.body, req.version
+	type partition struct {
+		index  int32
+		offset int64
+	}
+	type topic struct {
+		name       string
+		partitions []partition
+	}
+	group := d.string()
+	d.int32()  // generation
+	d.string() // member ID
+	if v >= 7 {
+		d.nullableString() // group instance ID
+	}
+	if v <= 4 {
+		d.int64() // retention time
+	}
+	n := d.arrayLen()
+	topics := make([]topic, 0, max(n, 0))
+	for range n {
+		t := topic{name: d.string()}
+		for range d.arrayLen() {
+			p := partition{index: d.int32(), offset: d.int64()}
+			if v >= 6 {
+				d.int32() // committed leader epoch
+			}
+			d.nullableString() // metadata
+			t.partitions = append(t.partitions, p)
+		}
+		topics = append(topics, t)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	e := &encoder{}
+	if v >= 3 {
+		e.int32(0) // throttle time
+	}
+	e.arrayLen(len(topics))
+	for _, t := range topics {
+		e.string(t.name)
+		e.arrayLen(len(t.partitions))
+		for _, p := range t.partitions {
+			topic, code := c.partitionError(t.name, p.index)
+			switch {
+			case group == "":
+				code = errInvalidGroupID
+			case code != errNone:
+			case p.offset < 0:
+				code = errOffsetOutOfRange
+			default:
+				if err := c.topics.Commit(ctx, topic, group, uint64(p.offset)); err != nil {
+					code = c.serverError("Failed to commit Kafka offset", topic, err)
+				}
+			}
+			e.int32(p.index)
+			e.int16(code)
+		}
+	}
+	return e.buf, nil
+}
+
+// offsetFetch returns where groups resume partitions, -1 for partitions a
+// group never consumed
+func (c *conn) offsetFetch(ctx context.Context, req *request) ([]byte, error) {
+	d, v := req.body, req.version
+	type topic struct {
+		name       string
+		partitions []int32
+	}
+	group := d.string()
+	n := d.arrayLen()
+	topics := make([]topic, 0, max(n, 0))
+	for range n {
+		t := topic{name: d.string()}
+		for range d.arrayLen() {
+			t.partitions = append(t.partitions, d.int32())
+		}
+		topics = append(topics, t)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	// All topics the group consumed are asked for with null
+	if n < 0 && group != "" {
+		infos, err := c.topics.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			name, ok := c.kafkaTopic(info.Topic)
+			if _, consumed := info.Groups[group]; ok && consumed {
+				topics = append(topics, topic{name: name, partitions: []int32{0}})
+			}
+		}
+	}
+
+	e := &encoder{}
+	if v >= 3 {
+		e.int32(0) // throttle time
+	}
+	e.arrayLen(len(topics))
+	for _, t := range topics {
+		e.string(t.name)
+		e.arrayLen(len(t.partitions))
+		for _, index := range t.partitions {
+			offset := int64(-1)
+			topic, code := c.partitionError(t.name, index)
+			switch {
+			case group == "":
+				code = errInvalidGroupID
+			case code != errNone:
+			default:
+				committed, ok, err := c.topics.Committed(ctx, topic, group)
+				switch {
+				case err != nil:
+					code = c.serverError("Failed to read Kafka offset", topic, err)
+				case ok:
+					offset = int64(committed)
+				}
+			}
+			e.int32(index)
+			e.int64(offset)
+			if v >= 5 {
+				e.int32(-1) // committed leader epoch
+			}
+			e.string("") // metadata
+			e.int16(code)
+		}
+	}
+	if v >= 2 {
+		code := errNone
+		if group == "" {
+			code = errInvalidGroupID
+		}
+		e.int16(code)
+	}
+	return e.buf, nil
+}
@@ -0,0 +1,248 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// API keys of the requests the server answers
+const (
+	apiProduce         int16 = 0
+	apiFetch           int16 = 1
+	apiListOffsets     int16 = 2
+	apiMetadata        int16 = 3
+	apiOffsetCommit    int16 = 8
+	apiOffsetFetch     int16 = 9
+	apiFindCoordinator int16 = 10
+	apiVersions        int16 = 18
+)
+
+// versionRange is the versions of a request the server answers. Only
+// versions before the flexible (tagged field) encoding are supported.
+type versionRange struct {
+	key      int16
+	min, max int16
+}
+
+// supported lists the requests the server answers, in ApiVersions order
+var supported = []versionRange{
+	{apiProduce, 3, 8},
+	{apiFetch, 4, 11},
+	{apiListOffsets, 1, 5},
+	{apiMetadata, 0, 8},
+	{apiOffsetCommit, 2, 7},
+	{apiOffsetFetch, 1, 5},
+	{apiFindCoordinator, 0, 2},
+	{apiVersions, 0, 2},
+}
+
+func supportedVersions(key int16) (versionRange, bool) {
+	for _, v := range supported {
+		if v.key == key {
+			return v, true
+		}
+	}
+	return versionRange{}, false
+}
+
+// Error codes of the protocol
+const (
+	errUnknownServerError         int16 = -1
+	errNone                       int16 = 0
+	errOffsetOutOfRange           int16 = 1
+	errCorruptMessage             int16 = 2
+	errUnknownTopicOrPartition    int16 = 3
+	errMessageTooLarge            int16 = 10
+	errCoordinatorNotAvailable    int16 = 15
+	errInvalidTopic               int16 = 17
+	errInvalidRequiredAcks        int16 = 21
+	errInvalidGroupID             int16 = 24
+	errUnsupportedVersion         int16 = 35
+	errUnsupportedForMessageFmt   int16 = 43
+	errFetchSessionIDNotFound     int16 = 70
+	errUnsupportedCompressionType int16 = 76
+)
+
+// noAuthorizedOperations is sent for authorized operations nobody asked for
+const noAuthorizedOperations int32 = -2147483648
+
+var errMalformed = errors.New("kafka: malformed request")
+
+// decoder reads the fields of a request. The first error sticks, so
+// fields are read without checking each one.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.buf) {
+		d.err = errMalformed
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+	return int8(b[0])
+}
+
+func (d *decoder) bool() bool { return d.int8() != 0 }
+
+func (d *decoder) int16() int16 {
+	b := d.take(2)
+	if b == nil {
+		return 0
+	}
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+func (d *decoder) int32() int32 {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) int64() int64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+// nullableString returns a string, false when it is null
+func (d *decoder) nullableString() (string, bool) {
+	n := d.int16()
+	if n < 0 {
+		return "", false
+	}
+	return string(d.take(int(n))), true
+}
+
+func (d *decoder) string() string {
+	s, _ := d.nullableString()
+	return s
+}
+
+// bytes returns nil for null bytes
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.take(int(n))
+}
+
+// arrayLen returns the number of elements of an array, -1 when it is null
+func (d *decoder) arrayLen() int {
+	n := int(d.int32())
+	// Every element takes a byte at least
+	if n > len(d.buf) {
+		d.err = errMalformed
+		return 0
+	}
+	if n < 0 {
+		return -1
+	}
+	return n
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errMalformed
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// varbytes reads bytes prefixed by a varint length, nil when null
+func (d *decoder) varbytes() []byte {
+	n := d.varint()
+	if n < 0 {
+		return nil
+	}
+	return d.take(int(n))
+}
+
+// encoder writes the fields of a response
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) int8(v int8) { e.buf = append(e.buf, byte(v)) }
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.int8(1)
+	} else {
+		e.int8(0)
+	}
+}
+
+func (e *encoder) int16(v int16) { e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v)) }
+
+func (e *encoder) int32(v int32) { e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v)) }
+
+func (e *encoder) int64(v int64) { e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v)) }
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// nullableString writes "" as null
+func (e *encoder) nullableString(s string) {
+	if s == "" {
+		e.int16(-1)
+		return
+	}
+	e.string(s)
+}
+
+// bytes writes nil as null
+func (e *encoder) bytes(b []byte) {
+	if b == nil {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) arrayLen(n int) { e.int32(int32(n)) }
+
+func (e *encoder) int32s(values ...int32) {
+	e.arrayLen(len(values))
+	for _, v := range values {
+		e.int32(v)
+	}
+}
+
+func (e *encoder) varint(v int64) { e.buf = binary.AppendVarint(e.buf, v) }
+
+// varbytes writes bytes prefixed by a varint length, nil as null
+func (e *encoder) varbytes(b []byte) {
+	if b == nil {
+		e.varint(-1)
+		return
+	}
+	e.varint(int64(len(b)))
+	e.buf = append(e.buf, b...)
+}
@@ -0,0 +1,182 @@
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"github.com/Skpow1234/Peervault/internal/pubsub"
+	"github.com/Skpow1234/Peervault/internal/streamlog"
+)
+
+// Record batches (message format v2) carry the records of produce and fetch
+// requests. Only the values of records are kept: keys, headers and the
+// timestamps of producers are dropped, and messages are timestamped when
+// they are stored.
+
+const (
+	batchMagic = 2
+	// batchHeaderSize is the size of a batch up to its records
+	batchHeaderSize = 61
+
+	attrCompression   = 0x07
+	attrTransactional = 0x10
+	attrControl       = 0x20
+
+	compressionNone = 0
+	compressionGzip = 1
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// batchError is an error code a produce request fails with
+type batchError struct {
+	code    int16
+	message string
+}
+
+func (e *batchError) Error() string { return e.message }
+
+// decodeBatches returns the values of the records of the batches in data
+func decodeBatches(data []byte) ([][]byte, error) {
+	var values [][]byte
+	for len(data) > 0 {
+		if len(data) < 17 {
+			return nil, &batchError{errCorruptMessage, "truncated record batch"}
+		}
+		length := int(int32(binary.BigEndian.Uint32(data[8:12])))
+		magic := data[16]
+		if magic != batchMagic {
+			return nil, &batchError{errUnsupportedForMessageFmt, "only record batches of magic 2 are supported"}
+		}
+		if length < batchHeaderSize-12 || 12+length > len(data) {
+			return nil, &batchError{errCorruptMessage, "truncated record batch"}
+		}
+		batch := data[:12+length]
+		data = data[12+length:]
+
+		if crc32.Checksum(batch[21:], castagnoli) != binary.BigEndian.Uint32(batch[17:21]) {
+			return nil, &batchError{errCorruptMessage, "record batch checksum mismatch"}
+		}
+		attributes := int16(binary.BigEndian.Uint16(batch[21:23]))
+		if attributes&attrControl != 0 {
+			continue
+		}
+		if attributes&attrTransactional != 0 {
+			return nil, &batchError{errUnsupportedForMessageFmt, "transactions are not supported"}
+		}
+		count := int(int32(binary.BigEndian.Uint32(batch[57:61])))
+		records, err := decompress(attributes&attrCompression, batch[batchHeaderSize:])
+		if err != nil {
+			return nil, err
+		}
+
+		if count < 0 || count > len(records) {
+			return nil, &batchError{errCorruptMessage, "malformed record batch"}
+		}
+		d := &decoder{buf: records}
+		for range count {
+			length := d.varint()
+			record := &decoder{buf: d.take(int(length))}
+			record.int8()     // attributes
+			record.varint()   // timestamp delta
+			record.varint()   // offset delta
+			record.varbytes() // key
+			value := record.varbytes()
+			headers := record.varint()
+			for i := int64(0); i < headers && record.err == nil; i++ {
+				record.varbytes()
+				record.varbytes()
+			}
+			if d.err != nil || record.err != nil || headers < 0 {
+				return nil, &batchError{errCorruptMessage, "malformed record"}
+			}
+			if len(value) > streamlog.MaxRecordSize {
+				return nil, &batchError{errMessageTooLarge, "record too large"}
+			}
+			values = append(values, bytes.Clone(value))
+		}
+	}
+	return values, nil
+}
+
+func decompress(codec int16, records []byte) ([]byte, error) {
+	switch codec {
+	case compressionNone:
+		return records, nil
+	case compressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(records))
+		if err != nil {
+			return nil, &batchError{errCorruptMessage, "malformed gzip records"}
+		}
+		defer r.Close()
+		// Bound what a small compressed batch may expand to
+		data, err := io.ReadAll(io.LimitReader(r, maxRequestSize+1))
+		if err != nil || len(data) > maxRequestSize {
+			return nil, &batchError{errCorruptMessage, "malformed gzip records"}
+		}
+		return data, nil
+	default:
+		return nil, &batchError{errUnsupportedCompressionType, "only gzip compression is supported"}
+	}
+}
+
+// encodeBatch returns messages as one uncompressed record batch
+func encodeBatch(messages []pubsub.Message) []byte {
+	if len(messages) == 0 {
+		return nil
+	}
+	first := messages[0]
+	base := first.Time.UnixMilli()
+	maxTime := base
+	records := &encoder{}
+	for _, m := range messages {
+		ms := m.Time.UnixMilli()
+		maxTime = max(maxTime, ms)
+		record := &encoder{}
+		record.int8(0)
+		record.varint(ms - base)
+		record.varint(int64(m.Offset - first.Offset))
+		record.varint(-1) // key
+		record.varbytes(m.Payload)
+		record.varint(0) // headers
+		records.varint(int64(len(record.buf)))
+		records.buf = append(records.buf, record.buf...)
+	}
+
+	e := &encoder{}
+	e.int64(int64(first.Offset))
+	e.int32(int32(batchHeaderSize - 12 + len(records.buf)))
+	e.int32(-1) // partition leader epoch
+	e.int8(batchMagic)
+	e.int32(0) // checksum, set below
+	e.int16(compressionNone)
+	e.int32(int32(messages[len(messages)-1].Offset - first.Offset))
+	e.int64(base)
+	e.int64(maxTime)
+	e.int64(-1) // producer ID
+	e.int16(-1) // producer epoch
+	e.int32(-1) // base sequence
+	e.int32(int32(len(messages)))
+	e.buf = append(e.buf, records.buf...)
+	binary.BigEndian.PutUint32(e.buf[17:21], crc32.Checksum(e.buf[21:], castagnoli))
+	return e.buf
+}
+
+// produceError returns the error code of a failed produce
+func produceError(err error) int16 {
+	var be *batchError
+	switch {
+	case errors.As(err, &be):
+		return be.code
+	case errors.Is(err, pubsub.ErrNotDurable):
+		return errInvalidTopic
+	case errors.Is(err, streamlog.ErrTooLarge):
+		return errMessageTooLarge
+	default:
+		return errUnknownServerError
+	}
+}
@@ -0,0 +1,248 @@
+// Package kafka serves the durable topics of a node, see
+// fileserver.Server.Topics, over the Kafka wire protocol, so Kafka
+// producers and consumers use them with their own client libraries.
+//
+// The server answers a subset of the protocol as a single broker:
+// ApiVersions, Metadata, Produce, Fetch, ListOffsets, FindCoordinator,
+// OffsetCommit and OffsetFetch, in the versions before the flexible
+// encoding. Kafka topic "orders.created" is durable topic Prefix +
+// "orders/created" and has one partition, 0. Consumer groups are the
+// consumer groups of durable topics, so offsets committed over Kafka are
+// where the group resumes over the other APIs too, and the other way
+// round. The group membership protocol (JoinGroup, SyncGroup, Heartbeat)
+// is not served: consumers assign partitions themselves and commit their
+// offsets. Idempotent and transactional producers are not supported.
+package kafka
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/pubsub"
+)
+
+// maxRequestSize is the largest request in bytes
+const maxRequestSize = 100 << 20
+
+// nodeID is the ID of the one broker of the cluster
+const nodeID int32 = 0
+
+// Config holds the Kafka server configuration
+type Config struct {
+	// Prefix is prepended to Kafka topics to name their durable topic
+	Prefix string
+	// AdvertisedAddr is the host:port clients are told to connect to;
+	// the address a client connected to when empty
+	AdvertisedAddr string
+	// ClusterID is reported in metadata
+	ClusterID string
+	// IdleTimeout closes connections without requests for this long
+	IdleTimeout time.Duration
+}
+
+// DefaultConfig returns the default Kafka server configuration
+func DefaultConfig() *Config {
+	return &Config{
+		Prefix:      "durable/",
+		ClusterID:   "peervault",
+		IdleTimeout: 10 * time.Minute,
+	}
+}
+
+// Server answers Kafka clients
+type Server struct {
+	node   *fileserver.Server
+	config *Config
+	logger *slog.Logger
+}
+
+// NewServer creates a Kafka server for the durable topics of node
+func NewServer(node *fileserver.Server, config *Config, logger *slog.Logger) *Server {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Server{node: node, config: config, logger: logger}
+}
+
+// Serve answers the clients accepting on listener until ctx is done
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	var topics *pubsub.Topics
+	if s.node != nil {
+		topics = s.node.Topics()
+	}
+	if topics == nil {
+		return errors.New("kafka: durable topics are not enabled")
+	}
+
+	// Connections are closed when Serve returns, and waited for
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(connCtx, topics, conn)
+		}()
+	}
+}
+
+// request is a request of a client
+type request struct {
+	key           int16
+	version       int16
+	correlationID int32
+	clientID      string
+	body          *decoder
+}
+
+// conn is the connection of a client
+type conn struct {
+	server *Server
+	topics *pubsub.Topics
+	net.Conn
+	// host and port are where the client is told to find the broker
+	host string
+	port int32
+}
+
+func (s *Server) serveConn(ctx context.Context, topics *pubsub.Topics, nc net.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { nc.Close() })
+	defer stop()
+	defer nc.Close()
+
+	c := &conn{server: s, topics: topics, Conn: nc}
+	if err := c.advertise(); err != nil {
+		s.logger.Error("Failed to determine the advertised address", "error", err)
+		return
+	}
+	r := bufio.NewReader(nc)
+	w := bufio.NewWriter(nc)
+	for {
+		if s.config.IdleTimeout > 0 {
+			_ = nc.SetReadDeadline(time.Now().Add(s.config.IdleTimeout))
+		}
+		req, err := readRequest(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && ctx.Err() == nil {
+				s.logger.Debug("Closing Kafka connection", "error", err, "remote", nc.RemoteAddr())
+			}
+			return
+		}
+		body, err := c.handle(ctx, req)
+		if err != nil {
+			s.logger.Debug("Closing Kafka connection", "error", err, "remote", nc.RemoteAddr(),
+				"apiKey", req.key, "apiVersion", req.version, "clientId", req.clientID)
+			return
+		}
+		if body == nil {
+			continue
+		}
+		var header [8]byte
+		binary.BigEndian.PutUint32(header[:4], uint32(4+len(body)))
+		binary.BigEndian.PutUint32(header[4:], uint32(req.correlationID))
+		w.Write(header[:])
+		w.Write(body)
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// advertise sets where clients are told to find the broker
+func (c *conn) advertise() error {
+	addr := c.server.config.AdvertisedAddr
+	if addr == "" {
+		addr = c.LocalAddr().String()
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port in %q", addr)
+	}
+	c.host, c.port = host, int32(n)
+	return nil
+}
+
+func readRequest(r *bufio.Reader) (*request, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := int32(binary.BigEndian.Uint32(size[:]))
+	if n < 8 || n > maxRequestSize {
+		return nil, fmt.Errorf("invalid request size %d", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	d := &decoder{buf: buf}
+	req := &request{key: d.int16(), version: d.int16(), correlationID: d.int32(), body: d}
+	req.clientID, _ = d.nullableString()
+	return req, d.err
+}
+
+// handle answers a request, with a nil body when no response is due. An
+// error closes the connection, which is how brokers answer requests they
+// cannot parse.
+func (c *conn) handle(ctx context.Context, req *request) ([]byte, error) {
+	versions, ok := supportedVersions(req.key)
+	if !ok {
+		return nil, fmt.Errorf("unsupported API key %d", req.key)
+	}
+	if req.version < versions.min || req.version > versions.max {
+		if req.key == apiVersions {
+			// Answered in version 0, which clients fall back to
+			return c.apiVersions(0, errUnsupportedVersion), nil
+		}
+		return nil, fmt.Errorf("unsupported version %d of API key %d", req.version, req.key)
+	}
+
+	switch req.key {
+	case apiVersions:
+		return c.apiVersions(req.version, errNone), nil
+	case apiMetadata:
+		return c.metadata(ctx, req)
+	case apiProduce:
+		return c.produce(ctx, req)
+	case apiFetch:
+		return c.fetch(ctx, req)
+	case apiListOffsets:
+		return c.listOffsets(ctx, req)
+	case apiFindCoordinator:
+		return c.findCoordinator(req)
+	case apiOffsetCommit:
+		return c.offsetCommit(ctx, req)
+	default:
+		return c.offsetFetch(ctx, req)
+	}
+}
@@ -0,0 +1,303 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/pubsub"
+	"github.com/Skpow1234/Peervault/internal/storage"
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// client sends requests the way Kafka clients do
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	next int32
+}
+
+func (c *client) request(key, version int16, body func(e *encoder)) *decoder {
+	c.t.Helper()
+	c.next++
+	e := &encoder{}
+	e.int16(key)
+	e.int16(version)
+	e.int32(c.next)
+	e.nullableString("test")
+	body(e)
+
+	require.NoError(c.t, c.conn.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err := c.conn.Write(binary.BigEndian.AppendUint32(nil, uint32(len(e.buf))))
+	require.NoError(c.t, err)
+	_, err = c.conn.Write(e.buf)
+	require.NoError(c.t, err)
+
+	var size [4]byte
+	_, err = io.ReadFull(c.conn, size[:])
+	require.NoError(c.t, err)
+	buf := make([]byte, binary.BigEndian.Uint32(size[:]))
+	_, err = io.ReadFull(c.conn, buf)
+	require.NoError(c.t, err)
+	d := &decoder{buf: buf}
+	require.Equal(c.t, c.next, d.int32(), "responses answer requests in order")
+	return d
+}
+
+// produce sends values to partition 0 of topic and returns the error code
+// and base offset
+func (c *client) produce(topic string, values ...string) (int16, int64) {
+	c.t.Helper()
+	messages := make([]pubsub.Message, len(values))
+	for i, v := range values {
+		messages[i] = pubsub.Message{Offset: uint64(i), Time: time.Now(), Payload: []byte(v)}
+	}
+	d := c.request(apiProduce, 3, func(e *encoder) {
+		e.nullableString("")
+		e.int16(1)
+		e.int32(1000)
+		e.arrayLen(1)
+		e.string(topic)
+		e.arrayLen(1)
+		e.int32(0)
+		e.bytes(encodeBatch(messages))
+	})
+	require.Equal(c.t, 1, d.arrayLen())
+	assert.Equal(c.t, topic, d.string())
+	require.Equal(c.t, 1, d.arrayLen())
+	assert.Equal(c.t, int32(0), d.int32())
+	code, base := d.int16(), d.int64()
+	require.NoError(c.t, d.err)
+	return code, base
+}
+
+// fetch reads partition 0 of topic from offset on and returns the error
+// code, high watermark and values
+func (c *client) fetch(topic string, offset int64, maxWait time.Duration) (int16, int64, []string) {
+	c.t.Helper()
+	d := c.request(apiFetch, 4, func(e *encoder) {
+		e.int32(-1)
+		e.int32(int32(maxWait.Milliseconds()))
+		e.int32(1)
+		e.int32(1 << 20)
+		e.int8(0)
+		e.arrayLen(1)
+		e.string(topic)
+		e.arrayLen(1)
+		e.int32(0)
+		e.int64(offset)
+		e.int32(1 << 20)
+	})
+	d.int32() // throttle time
+	require.Equal(c.t, 1, d.arrayLen())
+	assert.Equal(c.t, topic, d.string())
+	require.Equal(c.t, 1, d.arrayLen())
+	d.int32()
+	code, watermark := d.int16(), d.int64()
+	d.int64()
+	d.arrayLen()
+	values, err := decodeBatches(d.bytes())
+	require.NoError(c.t, err)
+	require.NoError(c.t, d.err)
+	var out []string
+	for _, v := range values {
+		out = append(out, string(v))
+	}
+	return code, watermark, out
+}
+
+func startServer(t *testing.T) (*fileserver.Server, *client) {
+	t.Chdir(t.TempDir())
+	node := fileserver.New(fileserver.Options{
+		ID:                "node-1",
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		Topics:            &pubsub.Config{Prefixes: []string{"durable/"}},
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(node, DefaultConfig(), slog.New(slog.NewTextHandler(io.Discard, nil))).Serve(ctx, listener)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+	})
+	return node, &client{t: t, conn: conn}
+}
+
+func TestApiVersions(t *testing.T) {
+	_, c := startServer(t)
+
+	d := c.request(apiVersions, 2, func(*encoder) {})
+	assert.Equal(t, errNone, d.int16())
+	n := d.arrayLen()
+	keys := make(map[int16][2]int16)
+	for range n {
+		keys[d.int16()] = [2]int16{d.int16(), d.int16()}
+	}
+	require.NoError(t, d.err)
+	assert.Equal(t, [2]int16{3, 8}, keys[apiProduce])
+	assert.Equal(t, [2]int16{4, 11}, keys[apiFetch])
+
+	// Newer versions are answered in version 0 so clients fall back
+	d = c.request(apiVersions, 3, func(*encoder) {})
+	assert.Equal(t, errUnsupportedVersion, d.int16())
+	assert.Equal(t, len(supported), d.arrayLen())
+}
+
+func TestProduceAndFetch(t *testing.T) {
+	node, c := startServer(t)
+
+	code, base := c.produce("orders.created", "a", "b")
+	require.Equal(t, errNone, code)
+	assert.Equal(t, int64(0), base)
+	code, base = c.produce("orders.created", "c")
+	require.Equal(t, errNone, code)
+	assert.Equal(t, int64(2), base)
+	code, _ = c.produce("orders..created", "x")
+	assert.Equal(t, errInvalidTopic, code)
+
+	// Kafka topics are durable topics, shared with the other APIs
+	messages, err := node.Topics().Read(context.Background(), "durable/orders/created", 0, 10)
+	require.NoError(t, err)
+	require.Len(t, messages, 3)
+	assert.Equal(t, "c", string(messages[2].Payload))
+
+	code, watermark, values := c.fetch("orders.created", 1, 0)
+	require.Equal(t, errNone, code)
+	assert.Equal(t, int64(3), watermark)
+	assert.Equal(t, []string{"b", "c"}, values)
+	code, _, _ = c.fetch("orders.created", 7, 0)
+	assert.Equal(t, errOffsetOutOfRange, code)
+
+	// Fetches at the end wait for the next message
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = node.Topics().Publish(context.Background(), "durable/orders/created", []byte("d"))
+	}()
+	started := time.Now()
+	_, _, values = c.fetch("orders.created", 3, 5*time.Second)
+	assert.Equal(t, []string{"d"}, values)
+	assert.Less(t, time.Since(started), 5*time.Second)
+
+	// Metadata lists the topics under the prefix
+	d := c.request(apiMetadata, 1, func(e *encoder) { e.arrayLen(-1) })
+	require.Equal(t, 1, d.arrayLen())
+	d.int32()
+	d.string()
+	assert.Positive(t, d.int32(), "the broker is where the client connected")
+	d.nullableString()
+	d.int32()
+	require.Equal(t, 1, d.arrayLen())
+	assert.Equal(t, errNone, d.int16())
+	assert.Equal(t, "orders.created", d.string())
+
+	// ListOffsets finds the oldest and next offsets
+	for timestamp, want := range map[int64]int64{latestTimestamp: 4, earliestTimestamp: 0} {
+		d := c.request(apiListOffsets, 1, func(e *encoder) {
+			e.int32(-1)
+			e.arrayLen(1)
+			e.string("orders.created")
+			e.arrayLen(1)
+			e.int32(0)
+			e.int64(timestamp)
+		})
+		d.arrayLen()
+		d.string()
+		d.arrayLen()
+		d.int32()
+		assert.Equal(t, errNone, d.int16())
+		d.int64()
+		assert.Equal(t, want, d.int64())
+	}
+}
+
+func TestOffsets(t *testing.T) {
+	node, c := startServer(t)
+	ctx := context.Background()
+	for _, v := range []string{"a", "b", "c"} {
+		_, err := node.Topics().Publish(ctx, "durable/jobs", []byte(v))
+		require.NoError(t, err)
+	}
+
+	commit := func(offset int64) int16 {
+		d := c.request(apiOffsetCommit, 2, func(e *encoder) {
+			e.string("workers")
+			e.int32(-1)
+			e.string("")
+			e.int64(-1)
+			e.arrayLen(1)
+			e.string("jobs")
+			e.arrayLen(1)
+			e.int32(0)
+			e.int64(offset)
+			e.nullableString("")
+		})
+		d.arrayLen()
+		d.string()
+		d.arrayLen()
+		d.int32()
+		return d.int16()
+	}
+	fetch := func() int64 {
+		d := c.request(apiOffsetFetch, 1, func(e *encoder) {
+			e.string("workers")
+			e.arrayLen(1)
+			e.string("jobs")
+			e.int32s(0)
+		})
+		d.arrayLen()
+		d.string()
+		d.arrayLen()
+		d.int32()
+		offset := d.int64()
+		d.string()
+		assert.Equal(t, errNone, d.int16())
+		return offset
+	}
+
+	assert.Equal(t, int64(-1), fetch(), "the group never consumed the topic")
+	require.Equal(t, errNone, commit(2))
+	assert.Equal(t, int64(2), fetch())
+
+	// The group resumes there over the other APIs
+	sub, err := node.Topics().Subscribe(ctx, "durable/jobs", "workers", pubsub.Resume)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), sub.Offset())
+	require.NoError(t, sub.Ack(ctx, 2))
+	assert.Equal(t, int64(3), fetch())
+
+	// Kafka consumers may rewind
+	require.Equal(t, errNone, commit(0))
+	assert.Equal(t, int64(0), fetch())
+}
+
+func TestRecordBatches(t *testing.T) {
+	now := time.Now()
+	batch := encodeBatch([]pubsub.Message{
+		{Offset: 5, Time: now, Payload: []byte("first")},
+		{Offset: 6, Time: now.Add(time.Second), Payload: []byte{}},
+	})
+	values, err := decodeBatches(append(batch, batch...))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("first"), {}, []byte("first"), {}}, values)
+
+	batch[len(batch)-1] ^= 0xff
+	_, err = decodeBatches(batch)
+	assert.Equal(t, errCorruptMessage, produceError(err), "checksums are verified")
+}
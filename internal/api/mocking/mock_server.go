@@ -49,7 +49,7 @@ type Scenario struct {
 
 // Condition defines when a scenario should be triggered
 type Condition struct {
-	Type     string      `json:"type"` // "header", "query", "body", "path"
+	Type     string      `json:"type"` // "header", "query", "body", "path", "method", "route"
 	Key      string      `json:"key"`
 	Value    interface{} `json:"value"`
 	Operator string      `json:"operator"` // "equals", "contains", "regex"
@@ -205,26 +205,19 @@ func (ms *MockServer) loadScenariosFromDir(dir string) error {
 	})
 }
 
-// loadScenariosFromOpenAPI loads scenarios from OpenAPI specification
+// loadScenariosFromOpenAPI loads a scenario for every documented response
+// of the OpenAPI specification, without replacing scenarios loaded from
+// files
 func (ms *MockServer) loadScenariosFromOpenAPI(specPath string) error {
-	// This would parse OpenAPI spec and generate scenarios
-	// For now, we'll create some basic scenarios
-	ms.scenarios["health-check"] = &Scenario{
-		Name:        "health-check",
-		Description: "Health check endpoint",
-		Response: &MockResponse{
-			StatusCode: 200,
-			Headers: map[string]string{
-				"Content-Type": "application/json",
-			},
-			Body: map[string]interface{}{
-				"status":    "healthy",
-				"timestamp": time.Now().UTC(),
-			},
-		},
-		Enabled: true,
+	doc, err := LoadOpenAPI(specPath)
+	if err != nil {
+		return err
+	}
+	for _, scenario := range ScenariosFromOpenAPI(doc) {
+		if _, exists := ms.scenarios[scenario.Name]; !exists {
+			ms.scenarios[scenario.Name] = scenario
+		}
 	}
-
 	return nil
 }
 
@@ -354,6 +347,11 @@ func (ms *MockServer) matchesCondition(r *http.Request, condition Condition) boo
 	var actualValue string
 
 	switch condition.Type {
+	case "method":
+		actualValue = r.Method
+	case "route":
+		// Matches the request path against an OpenAPI path template
+		return matchesRoute(fmt.Sprintf("%v", condition.Value), r.URL.Path)
 	case "header":
 		actualValue = r.Header.Get(condition.Key)
 	case "query":
@@ -0,0 +1,110 @@
+package mocking
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/openapi"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadOpenAPI reads an OpenAPI document in YAML or JSON
+func LoadOpenAPI(path string) (*openapi.Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenAPI spec: %w", err)
+	}
+	// JSON is valid YAML
+	var doc openapi.Document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI spec: %w", err)
+	}
+	if len(doc.Paths) == 0 {
+		return nil, fmt.Errorf("OpenAPI spec %s has no paths", path)
+	}
+	return &doc, nil
+}
+
+// ScenariosFromOpenAPI derives scenarios from the responses documented for
+// every operation. The first success response of an operation is enabled;
+// the others, named <operationId>-<status>, can be enabled to mock errors.
+// Bodies are examples built from the response schemas.
+func ScenariosFromOpenAPI(doc *openapi.Document) []*Scenario {
+	var scenarios []*Scenario
+	doc.EachOperation(func(method, path string, op *openapi.OperationObject) {
+		statuses := make([]string, 0, len(op.Responses))
+		for status := range op.Responses {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+
+		enabled := false
+		for _, status := range statuses {
+			code, err := strconv.Atoi(status)
+			if err != nil {
+				continue
+			}
+			success := code >= 200 && code < 300
+			scenario := &Scenario{
+				Name:        op.OperationID + "-" + status,
+				Description: fmt.Sprintf("%s %s: %s", method, path, op.Responses[status].Description),
+				Conditions: []Condition{
+					{Type: "method", Value: method, Operator: "equals", Required: true},
+					{Type: "route", Value: path, Operator: "equals", Required: true},
+				},
+				Response: exampleResponse(doc, code, op.Responses[status]),
+				Enabled:  success && !enabled,
+			}
+			if scenario.Enabled {
+				enabled = true
+				scenario.Name = op.OperationID
+			}
+			scenarios = append(scenarios, scenario)
+		}
+	})
+	return scenarios
+}
+
+// exampleResponse mocks a documented response, preferring JSON content
+func exampleResponse(doc *openapi.Document, code int, resp openapi.ResponseObject) *MockResponse {
+	mock := &MockResponse{StatusCode: code, Headers: map[string]string{}}
+	if media, ok := resp.Content["application/json"]; ok {
+		mock.Headers["Content-Type"] = "application/json"
+		mock.Body = doc.Example(media.Schema)
+		return mock
+	}
+	for contentType := range resp.Content {
+		mock.Headers["Content-Type"] = contentType
+		if strings.HasPrefix(contentType, "text/") {
+			mock.Body = http.StatusText(code)
+		}
+		break
+	}
+	return mock
+}
+
+// matchesRoute reports whether path matches an OpenAPI path template, in
+// which each {param} stands for one segment
+func matchesRoute(template, path string) bool {
+	want := strings.Split(strings.Trim(template, "/"), "/")
+	got := strings.Split(strings.Trim(path, "/"), "/")
+	if len(want) != len(got) {
+		return false
+	}
+	for i, segment := range want {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if got[i] == "" {
+				return false
+			}
+			continue
+		}
+		if segment != got[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -2,13 +2,17 @@ package mqtt
 
 import (
 	"context"
+	"crypto/tls"
 	"log/slog"
 	"net"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/Skpow1234/Peervault/internal/api/mqtt/topicacl"
 	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/firmware"
+	"github.com/Skpow1234/Peervault/internal/telemetry"
 )
 
 // Broker represents the MQTT broker
@@ -37,6 +41,9 @@ type Broker struct {
 	cancel context.CancelFunc
 }
 
+// tlsHandshakeTimeout bounds the TLS handshake of new connections
+const tlsHandshakeTimeout = 10 * time.Second
+
 // BrokerConfig holds the configuration for the MQTT broker
 type BrokerConfig struct {
 	Port            int
@@ -49,6 +56,23 @@ type BrokerConfig struct {
 	RetainEnabled   bool
 	WillEnabled     bool
 	CleanSession    bool
+	// WebSocketTLS serves MQTT over secure WebSockets; nil serves plain
+	// WebSockets
+	WebSocketTLS *tls.Config
+	// TLS serves MQTT over TLS on the TCP listener; nil serves plain MQTT.
+	// When it verifies client certificates, as the configs of
+	// devices.CA.ClientTLS do, clients are known by the device their
+	// certificate names.
+	TLS *tls.Config
+	// ACL decides which topics clients may publish to and subscribe to;
+	// nil allows every topic
+	ACL *topicacl.ACL
+	// Firmware serves devices the firmware updates of its rollouts, see
+	// handleFirmware; nil serves no firmware
+	Firmware *firmware.Manager
+	// Telemetry records the readings devices publish, see recordTelemetry;
+	// nil records none
+	Telemetry *telemetry.Collector
 }
 
 // BrokerStats holds broker statistics
@@ -80,6 +104,10 @@ func NewBroker(fileserver *fileserver.Server, config *BrokerConfig, logger *slog
 		cancel: cancel,
 	}
 
+	if config.Firmware != nil {
+		config.Firmware.Watch(broker.announceFirmware)
+	}
+
 	// Start background tasks
 	go broker.startBackgroundTasks()
 
@@ -120,8 +148,9 @@ func (b *Broker) ServeWebSocket(ctx context.Context, addr string) error {
 	mux.HandleFunc("/mqtt", b.handleWebSocketUpgrade)
 
 	server := &http.Server{
-		Addr:    addr,
-		Handler: mux,
+		Addr:      addr,
+		Handler:   mux,
+		TLSConfig: b.config.WebSocketTLS,
 	}
 
 	// Start server in goroutine
@@ -134,11 +163,33 @@ func (b *Broker) ServeWebSocket(ctx context.Context, addr string) error {
 		}
 	}()
 
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
 	return server.ListenAndServe()
 }
 
 // handleConnection handles a new TCP connection
 func (b *Broker) handleConnection(conn net.Conn) {
+	deviceID, tenant := "", ""
+	if b.config.TLS != nil {
+		tlsConn := tls.Server(conn, b.config.TLS)
+		ctx, cancel := context.WithTimeout(b.ctx, tlsHandshakeTimeout)
+		err := tlsConn.HandshakeContext(ctx)
+		cancel()
+		if err != nil {
+			b.logger.Warn("MQTT TLS handshake failed", "error", err, "remoteAddr", conn.RemoteAddr())
+			_ = conn.Close()
+			return
+		}
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			deviceID = certs[0].Subject.CommonName
+			if org := certs[0].Subject.Organization; len(org) > 0 {
+				tenant = org[0]
+			}
+		}
+		conn = tlsConn
+	}
 	defer func() {
 		if err := conn.Close(); err != nil {
 			b.logger.Error("Failed to close connection", "error", err)
@@ -147,6 +198,8 @@ func (b *Broker) handleConnection(conn net.Conn) {
 
 	// Create client
 	client := NewClient(conn, b, b.logger)
+	client.DeviceID = deviceID
+	client.Tenant = tenant
 
 	// Handle client session
 	if err := client.Handle(); err != nil {
@@ -180,7 +233,7 @@ func (b *Broker) addClient(client *Client) {
 	b.logger.Info("Client connected",
 		"clientId", client.ID,
 		"remoteAddr", client.RemoteAddr(),
-		"activeConnections", b.getActiveConnections(),
+		"activeConnections", len(b.clients),
 	)
 }
 
@@ -207,20 +260,22 @@ func (b *Broker) removeClient(clientID string) {
 
 		b.logger.Info("Client disconnected",
 			"clientId", clientID,
-			"activeConnections", b.getActiveConnections(),
+			"activeConnections", len(b.clients),
 		)
 	}
 }
 
-// getActiveConnections returns the number of active connections
-func (b *Broker) getActiveConnections() int {
-	b.clientsMu.RLock()
-	defer b.clientsMu.RUnlock()
-	return len(b.clients)
-}
-
 // subscribeClient subscribes a client to a topic
 func (b *Broker) subscribeClient(client *Client, topicName string, qos QoS) error {
+	if topics := b.durableTopics(topicName); topics != nil {
+		b.logger.Info("Client subscribed to durable topic",
+			"clientId", client.ID,
+			"topic", topicName,
+			"qos", qos,
+		)
+		return client.followDurable(topics, topicName, qos)
+	}
+
 	b.topicsMu.Lock()
 	defer b.topicsMu.Unlock()
 
@@ -248,6 +303,8 @@ func (b *Broker) subscribeClient(client *Client, topicName string, qos QoS) erro
 
 // unsubscribeClient unsubscribes a client from a topic
 func (b *Broker) unsubscribeClient(client *Client, topicName string) error {
+	client.stopDurable(topicName)
+
 	b.topicsMu.Lock()
 	defer b.topicsMu.Unlock()
 
@@ -274,6 +331,17 @@ func (b *Broker) unsubscribeClientFromAllTopics(client *Client) {
 
 // publishMessage publishes a message to a topic
 func (b *Broker) publishMessage(message *Message) error {
+	// Subscribers of durable topics follow their log
+	if topics := b.durableTopics(message.Topic); topics != nil {
+		if _, err := topics.Publish(b.ctx, message.Topic, message.Payload); err != nil {
+			return err
+		}
+		b.updateStats(func(stats *BrokerStats) {
+			stats.TotalMessages++
+		})
+		return nil
+	}
+
 	b.topicsMu.RLock()
 	defer b.topicsMu.RUnlock()
 
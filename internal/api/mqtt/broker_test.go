@@ -0,0 +1,346 @@
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skpow1234/Peervault/internal/api/mqtt/topicacl"
+	"github.com/Skpow1234/Peervault/internal/devices"
+	"github.com/Skpow1234/Peervault/internal/firmware"
+	"github.com/Skpow1234/Peervault/internal/telemetry"
+)
+
+// connectPacket is an MQTT 3.1.1 CONNECT of client c1 with a clean session
+var connectPacket = []byte{0x10, 14, 0, 4, 'M', 'Q', 'T', 'T', 4, 0x02, 0, 60, 0, 2, 'c', '1'}
+
+// serverCertificate returns a self-signed certificate for 127.0.0.1
+func serverCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "broker"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// enroll returns a certificate of the device CA for a new device of a
+// tenant
+func enroll(t *testing.T, ca *devices.CA, id, tenant string) tls.Certificate {
+	t.Helper()
+	ctx := context.Background()
+	_, secret, err := ca.Register(ctx, id, tenant, "", "test")
+	require.NoError(t, err)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := devices.NewCSR(key, id, secret)
+	require.NoError(t, err)
+	csr, err := x509.ParseCertificateRequest(der)
+	require.NoError(t, err)
+	cert, err := ca.Enroll(ctx, devices.EnrollRequest{CSR: csr})
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key}
+}
+
+func TestBrokerDeviceCertificates(t *testing.T) {
+	ca, err := devices.New(devices.Options{})
+	require.NoError(t, err)
+	server := &tls.Config{Certificates: []tls.Certificate{serverCertificate(t)}, MinVersion: tls.VersionTLS12}
+	broker := NewBroker(nil, &BrokerConfig{KeepAlive: time.Minute, MaxMessageSize: 1024, TLS: ca.ClientTLS(server)}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = broker.ServeTCP(ctx, listener) }()
+
+	connect := func(cert tls.Certificate) error {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{cert}})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if _, err := conn.Write(connectPacket); err != nil {
+			return err
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		connack := make([]byte, 4)
+		if _, err := io.ReadFull(conn, connack); err != nil {
+			return err
+		}
+		assert.Equal(t, []byte{0x20, 2, 0, 0}, connack)
+		return nil
+	}
+
+	require.NoError(t, connect(enroll(t, ca, "sensor-1", "")))
+
+	revoked := enroll(t, ca, "sensor-2", "")
+	require.NoError(t, ca.Revoke(context.Background(), "sensor-2", "test"))
+	assert.Error(t, connect(revoked))
+
+	// Certificates of other CAs are refused
+	assert.Error(t, connect(serverCertificate(t)))
+}
+
+// mqttString encodes s as an MQTT length-prefixed string
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+func TestBrokerTopicACL(t *testing.T) {
+	ca, err := devices.New(devices.Options{})
+	require.NoError(t, err)
+	acl, err := topicacl.New(topicacl.Options{Rules: []topicacl.Rule{
+		{Action: topicacl.Allow, Topic: "devices/{device}/#"},
+		{Action: topicacl.Allow, Access: topicacl.Subscribe, Topic: "tenants/{tenant}/#"},
+	}})
+	require.NoError(t, err)
+	server := &tls.Config{Certificates: []tls.Certificate{serverCertificate(t)}, MinVersion: tls.VersionTLS12}
+	broker := NewBroker(nil, &BrokerConfig{KeepAlive: time.Minute, MaxMessageSize: 1024, TLS: ca.ClientTLS(server), ACL: acl}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = broker.ServeTCP(ctx, listener) }()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{enroll(t, ca, "sensor-1", "plant-a")}})
+	require.NoError(t, err)
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write(connectPacket)
+	require.NoError(t, err)
+	connack := make([]byte, 4)
+	_, err = io.ReadFull(conn, connack)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x20, 2, 0, 0}, connack)
+
+	subscribe := func(id byte, filter string) byte {
+		t.Helper()
+		body := append([]byte{0, id}, mqttString(filter)...)
+		body = append(body, 1)
+		_, err := conn.Write(append([]byte{0x82, byte(len(body))}, body...))
+		require.NoError(t, err)
+		suback := make([]byte, 5)
+		_, err = io.ReadFull(conn, suback)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x90, 3, 0, id}, suback[:4])
+		return suback[4]
+	}
+	assert.Equal(t, byte(1), subscribe(1, "devices/sensor-1/commands/#"))
+	assert.Equal(t, byte(1), subscribe(2, "tenants/plant-a/firmware"))
+	assert.Equal(t, byte(SUBACK_FAILURE), subscribe(3, "tenants/plant-b/firmware"))
+	assert.Equal(t, byte(SUBACK_FAILURE), subscribe(4, "#"))
+
+	// Publishing to another device's topics disconnects the client
+	body := append(mqttString("devices/sensor-2/commands/reboot"), "now"...)
+	_, err = conn.Write(append([]byte{0x30, byte(len(body))}, body...))
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+// readPublish reads a PUBLISH packet sent with QoS 0, returning its topic
+// and payload
+func readPublish(t *testing.T, r io.Reader) (string, []byte) {
+	t.Helper()
+	header := make([]byte, 1)
+	_, err := io.ReadFull(r, header)
+	require.NoError(t, err)
+	require.Equal(t, byte(0x30), header[0]&0xF0)
+	length, multiplier := 0, 1
+	for {
+		_, err := io.ReadFull(r, header)
+		require.NoError(t, err)
+		length += int(header[0]&0x7F) * multiplier
+		if header[0]&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	require.NoError(t, err)
+	n := int(body[0])<<8 | int(body[1])
+	return string(body[2 : 2+n]), body[2+n:]
+}
+
+func TestBrokerFirmware(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ca, err := devices.New(devices.Options{})
+	require.NoError(t, err)
+	cert := enroll(t, ca, "sensor-1", "")
+	manager, err := firmware.New(firmware.Options{Devices: ca})
+	require.NoError(t, err)
+	manager.Attach(memStore{})
+	data := bytes.Repeat([]byte("0123456789"), 60)
+	img, err := manager.AddImage(ctx, "sensor", "2.0.0", data, "", "admin")
+	require.NoError(t, err)
+
+	server := &tls.Config{Certificates: []tls.Certificate{serverCertificate(t)}, MinVersion: tls.VersionTLS12}
+	broker := NewBroker(nil, &BrokerConfig{KeepAlive: time.Minute, MaxMessageSize: 1024, TLS: ca.ClientTLS(server), Firmware: manager}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = broker.ServeTCP(ctx, listener) }()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{cert}})
+	require.NoError(t, err)
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write(connectPacket)
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	require.NoError(t, err)
+	for id, topic := range []string{"firmware/sensor-1/manifest", "firmware/sensor-1/block"} {
+		body := append([]byte{0, byte(id + 1)}, mqttString(topic)...)
+		body = append(body, 0)
+		_, err := conn.Write(append([]byte{0x82, byte(len(body))}, body...))
+		require.NoError(t, err)
+		suback := make([]byte, 5)
+		_, err = io.ReadFull(conn, suback)
+		require.NoError(t, err)
+		require.Equal(t, byte(0), suback[4])
+	}
+	publish := func(topic string, payload []byte) {
+		t.Helper()
+		body := append(mqttString(topic), payload...)
+		_, err := conn.Write(append([]byte{0x30, byte(len(body))}, body...))
+		require.NoError(t, err)
+	}
+
+	// Releasing an update announces it to the devices subscribed
+	_, err = manager.CreateRollout(ctx, firmware.RolloutRequest{ImageID: img.ID, Rings: []firmware.Ring{{Name: "canary", Devices: []string{"sensor-1"}}}}, "admin")
+	require.NoError(t, err)
+	topic, payload := readPublish(t, conn)
+	assert.Equal(t, "firmware/sensor-1/manifest", topic)
+	var manifest firmware.Manifest
+	require.NoError(t, json.Unmarshal(payload, &manifest))
+	assert.Equal(t, img, manifest.Image)
+
+	publish("firmware/sensor-1/check", []byte("1.0.0"))
+	topic, payload = readPublish(t, conn)
+	assert.Equal(t, "firmware/sensor-1/manifest", topic)
+	require.NoError(t, json.Unmarshal(payload, &manifest))
+	assert.Equal(t, "firmware/sensor-1/get", manifest.MQTTTopic)
+
+	// The image, block by block
+	var image []byte
+	for block := 0; len(image) < len(data); block++ {
+		publish(manifest.MQTTTopic, fmt.Appendf(nil, `{"image_id":%q,"block":%d}`, img.ID, block))
+		topic, payload = readPublish(t, conn)
+		assert.Equal(t, "firmware/sensor-1/block", topic)
+		assert.Equal(t, uint32(block), binary.BigEndian.Uint32(payload))
+		image = append(image, payload[4:]...)
+	}
+	assert.Equal(t, data, image)
+
+	publish("firmware/sensor-1/status", fmt.Appendf(nil, `{"image_id":%q,"state":"installed"}`, img.ID))
+	require.Eventually(t, func() bool {
+		status, err := manager.Status("sensor-1")
+		return err == nil && status.State == firmware.StateInstalled
+	}, time.Second, 10*time.Millisecond)
+	publish("firmware/sensor-1/check", []byte("2.0.0"))
+	topic, payload = readPublish(t, conn)
+	assert.Equal(t, "firmware/sensor-1/manifest", topic)
+	assert.Empty(t, payload)
+
+	// Asking for another device's updates disconnects the client
+	publish("firmware/sensor-2/check", []byte("1.0.0"))
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestBrokerTelemetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ca, err := devices.New(devices.Options{})
+	require.NoError(t, err)
+	cert := enroll(t, ca, "sensor-1", "")
+	collector := telemetry.NewCollector(telemetry.Options{})
+
+	server := &tls.Config{Certificates: []tls.Certificate{serverCertificate(t)}, MinVersion: tls.VersionTLS12}
+	broker := NewBroker(nil, &BrokerConfig{KeepAlive: time.Minute, MaxMessageSize: 1024, TLS: ca.ClientTLS(server), Telemetry: collector}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = broker.ServeTCP(ctx, listener) }()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{cert}})
+	require.NoError(t, err)
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write(connectPacket)
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	require.NoError(t, err)
+	for topic, payload := range map[string]string{
+		"telemetry/sensor-1":          `{"temperature": 21.5, "battery": 80}`,
+		"telemetry/sensor-1/humidity": "40",
+		"telemetry/sensor-1/status":   "ok",
+		"telemetry/sensor-2":          `{"temperature": 99}`,
+	} {
+		body := append(mqttString(topic), payload...)
+		_, err := conn.Write(append([]byte{0x30, byte(len(body))}, body...))
+		require.NoError(t, err)
+	}
+
+	// Readings of other devices and those that are not numbers are not
+	// recorded
+	require.Eventually(t, func() bool {
+		series, err := collector.Query(telemetry.Query{GroupBy: []telemetry.Dimension{telemetry.ByDevice, telemetry.ByMetric}})
+		return err == nil && len(series) == 3
+	}, time.Second, 10*time.Millisecond)
+	series, err := collector.Query(telemetry.Query{GroupBy: []telemetry.Dimension{telemetry.ByDevice, telemetry.ByMetric}})
+	require.NoError(t, err)
+	for i, metric := range []string{"battery", "humidity", "temperature"} {
+		assert.Equal(t, "sensor-1", series[i].Device)
+		assert.Equal(t, metric, series[i].Metric)
+	}
+	assert.Equal(t, 21.5, series[2].Points[0].Max)
+}
+
+// memStore keeps firmware images in memory
+type memStore map[string][]byte
+
+func (s memStore) Has(key string) bool { _, ok := s[key]; return ok }
+
+func (s memStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if data, ok := s[key]; ok {
+		return data, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+func (s memStore) Put(ctx context.Context, key string, data []byte) error {
+	s[key] = data
+	return nil
+}
+
+func (s memStore) Delete(ctx context.Context, key string) error {
+	delete(s, key)
+	return nil
+}
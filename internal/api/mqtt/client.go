@@ -9,8 +9,13 @@ import (
 	"io"
 	"log/slog"
 	"net"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/Skpow1234/Peervault/internal/api/mqtt/topicacl"
+	"github.com/Skpow1234/Peervault/internal/firmware"
+	"github.com/Skpow1234/Peervault/internal/telemetry"
 )
 
 // Client represents an MQTT client connection
@@ -20,6 +25,11 @@ type Client struct {
 
 	// Client identification
 	ID string
+	// DeviceID is the device named by the client's certificate, when the
+	// broker verifies device certificates
+	DeviceID string
+	// Tenant is the organization named by the client's certificate
+	Tenant string
 
 	// Broker reference
 	broker *Broker
@@ -51,8 +61,18 @@ type Client struct {
 	pendingPubcomps  map[uint16]*PendingPubcomp
 	pendingMu        sync.RWMutex
 
-	// Statistics
-	stats *ClientStats
+	// deliveries holds the acknowledgments of messages sent with QoS 1
+	// or 2 by packet ID, under pendingMu
+	deliveries   map[uint16]func()
+	lastPacketID uint16
+
+	// durable stops following the durable topics the client subscribed to
+	durable   map[string]context.CancelFunc
+	durableMu sync.Mutex
+
+	// Statistics, under statsMu
+	stats   *ClientStats
+	statsMu sync.Mutex
 
 	// Context for cancellation
 	ctx    context.Context
@@ -120,6 +140,8 @@ func NewClient(conn net.Conn, broker *Broker, logger *slog.Logger) *Client {
 		pendingPubrecs:   make(map[uint16]*PendingPubrec),
 		pendingPubrels:   make(map[uint16]*PendingPubrel),
 		pendingPubcomps:  make(map[uint16]*PendingPubcomp),
+		deliveries:       make(map[uint16]func()),
+		durable:          make(map[string]context.CancelFunc),
 		stats: &ClientStats{
 			ConnectedAt:  time.Now(),
 			LastActivity: time.Now(),
@@ -148,7 +170,6 @@ func (c *Client) Handle() error {
 	// Start message processing goroutines
 	go c.processIncomingMessages()
 	go c.processOutgoingMessages()
-	go c.handleKeepAlive()
 
 	// Read packets from connection
 	for {
@@ -174,7 +195,9 @@ func (c *Client) Handle() error {
 		}
 
 		// Update activity
+		c.statsMu.Lock()
 		c.stats.LastActivity = time.Now()
+		c.statsMu.Unlock()
 
 		// Process packet
 		if err := c.processPacket(packet); err != nil {
@@ -186,78 +209,18 @@ func (c *Client) Handle() error {
 
 // readPacket reads a packet from the connection
 func (c *Client) readPacket() (*Packet, error) {
-	// Read fixed header
-	header, err := c.readFixedHeader()
+	packet, err := ReadPacket(c.conn, c.broker.config.MaxMessageSize)
 	if err != nil {
 		return nil, err
 	}
 
-	// Read variable header and payload
-	packet := &Packet{
-		Header: header,
-	}
-
-	// Read remaining length
-	remainingLength, err := c.readRemainingLength()
-	if err != nil {
-		return nil, err
-	}
-
-	if remainingLength > 0 {
-		// Read variable header and payload
-		data := make([]byte, remainingLength)
-		if _, err := io.ReadFull(c.conn, data); err != nil {
-			return nil, err
-		}
-
-		packet.Data = data
-		c.stats.BytesReceived += int64(remainingLength)
-	}
+	c.statsMu.Lock()
+	c.stats.BytesReceived += int64(len(packet.Data))
+	c.statsMu.Unlock()
 
 	return packet, nil
 }
 
-// readFixedHeader reads the fixed header from the connection
-func (c *Client) readFixedHeader() (*FixedHeader, error) {
-	headerBytes := make([]byte, 1)
-	if _, err := io.ReadFull(c.conn, headerBytes); err != nil {
-		return nil, err
-	}
-
-	header := &FixedHeader{
-		MessageType: MessageType(headerBytes[0] >> 4),
-		Flags:       headerBytes[0] & 0x0F,
-	}
-
-	return header, nil
-}
-
-// readRemainingLength reads the remaining length from the connection
-func (c *Client) readRemainingLength() (int, error) {
-	var multiplier = 1
-	var value = 0
-
-	for {
-		encodedByte := make([]byte, 1)
-		if _, err := io.ReadFull(c.conn, encodedByte); err != nil {
-			return 0, err
-		}
-
-		value += int(encodedByte[0]&127) * multiplier
-		multiplier *= 128
-
-		if multiplier > 128*128*128 {
-			return 0, fmt.Errorf("malformed remaining length")
-		}
-
-		if (encodedByte[0] & 128) == 0 {
-			break
-		}
-	}
-
-	return value, nil
-}
-
 // processPacket processes a received packet
 func (c *Client) processPacket(packet *Packet) error {
 	switch packet.Header.MessageType {
@@ -297,7 +260,7 @@ func (c *Client) processPacket(packet *Packet) error {
 // handleConnect handles a CONNECT packet
 func (c *Client) handleConnect(packet *Packet) error {
 	// Parse CONNECT packet
-	connect, err := c.parseConnectPacket(packet)
+	connect, err := ParseConnect(packet)
 	if err != nil {
 		return err
 	}
@@ -312,6 +275,16 @@ func (c *Client) handleConnect(packet *Packet) error {
 	c.cleanSession = connect.CleanSession
 	c.keepAlive = time.Duration(connect.KeepAlive) * time.Second
 
+	// Clients may not leave wills on topics they cannot publish to
+	if connect.WillFlag {
+		if err := c.authorize(topicacl.Publish, connect.WillTopic); err != nil {
+			if err := c.sendConnack(&ConnackPacket{ReturnCode: CONNACK_NOT_AUTHORIZED}); err != nil {
+				return err
+			}
+			return err
+		}
+	}
+
 	// Handle will message
 	if connect.WillFlag {
 		c.WillMessage = &Message{
@@ -333,7 +306,12 @@ func (c *Client) handleConnect(packet *Packet) error {
 	}
 
 	c.connected = true
-	c.logger.Info("Client connected", "clientId", c.ID, "keepAlive", c.keepAlive)
+	c.logger.Info("Client connected", "clientId", c.ID, "deviceId", c.DeviceID, "keepAlive", c.keepAlive)
+
+	// The keep-alive is negotiated here; zero turns it off
+	if c.keepAlive > 0 {
+		go c.handleKeepAlive()
+	}
 
 	return nil
 }
@@ -341,11 +319,25 @@ func (c *Client) handleConnect(packet *Packet) error {
 // handlePublish handles a PUBLISH packet
 func (c *Client) handlePublish(packet *Packet) error {
 	// Parse PUBLISH packet
-	publish, err := c.parsePublishPacket(packet)
+	publish, err := ParsePublish(packet)
 	if err != nil {
 		return err
 	}
 
+	// MQTT 3.1.1 cannot refuse a PUBLISH, so clients publishing where they
+	// may not are disconnected
+	if err := c.authorize(topicacl.Publish, publish.Topic); err != nil {
+		return err
+	}
+	if c.broker.config.Firmware != nil && strings.HasPrefix(publish.Topic, firmware.TopicPrefix) {
+		if err := c.handleFirmware(publish.Topic, publish.Payload); err != nil {
+			return err
+		}
+	}
+	if c.broker.config.Telemetry != nil && strings.HasPrefix(publish.Topic, telemetry.TopicPrefix) {
+		c.recordTelemetry(publish.Topic, publish.Payload)
+	}
+
 	// Create message
 	message := &Message{
 		Topic:   publish.Topic,
@@ -360,11 +352,12 @@ func (c *Client) handlePublish(packet *Packet) error {
 		// No acknowledgment required
 		return c.broker.publishMessage(message)
 	case QoS1:
-		// Send PUBACK
-		if err := c.sendPuback(publish.PacketID); err != nil {
+		// Acknowledged once published, so messages to durable topics
+		// that fail to be stored are sent again
+		if err := c.broker.publishMessage(message); err != nil {
 			return err
 		}
-		return c.broker.publishMessage(message)
+		return c.sendPuback(publish.PacketID)
 	case QoS2:
 		// Send PUBREC
 		if err := c.sendPubrec(publish.PacketID); err != nil {
@@ -381,7 +374,7 @@ func (c *Client) handlePublish(packet *Packet) error {
 // handleSubscribe handles a SUBSCRIBE packet
 func (c *Client) handleSubscribe(packet *Packet) error {
 	// Parse SUBSCRIBE packet
-	subscribe, err := c.parseSubscribePacket(packet)
+	subscribe, err := ParseSubscribe(packet)
 	if err != nil {
 		return err
 	}
@@ -389,7 +382,9 @@ func (c *Client) handleSubscribe(packet *Packet) error {
 	// Subscribe to topics
 	var returnCodes []byte
 	for _, subscription := range subscribe.Subscriptions {
-		if err := c.broker.subscribeClient(c, subscription.Topic, subscription.QoS); err != nil {
+		if err := c.authorize(topicacl.Subscribe, subscription.Topic); err != nil {
+			returnCodes = append(returnCodes, byte(SUBACK_FAILURE))
+		} else if err := c.broker.subscribeClient(c, subscription.Topic, subscription.QoS); err != nil {
 			returnCodes = append(returnCodes, byte(SUBACK_FAILURE))
 		} else {
 			returnCodes = append(returnCodes, byte(subscription.QoS))
@@ -406,10 +401,23 @@ func (c *Client) handleSubscribe(packet *Packet) error {
 	return c.sendSuback(suback)
 }
 
+// authorize checks the topic ACL of the broker, if any, for the client
+func (c *Client) authorize(access topicacl.Access, topic string) error {
+	if c.broker.config.ACL == nil {
+		return nil
+	}
+	return c.broker.config.ACL.Authorize(topicacl.Client{
+		ID:     c.ID,
+		Addr:   c.conn.RemoteAddr().String(),
+		Device: c.DeviceID,
+		Tenant: c.Tenant,
+	}, access, topic)
+}
+
 // handleUnsubscribe handles an UNSUBSCRIBE packet
 func (c *Client) handleUnsubscribe(packet *Packet) error {
 	// Parse UNSUBSCRIBE packet
-	unsubscribe, err := c.parseUnsubscribePacket(packet)
+	unsubscribe, err := ParseUnsubscribe(packet)
 	if err != nil {
 		return err
 	}
@@ -450,7 +458,9 @@ func (c *Client) processIncomingMessages() {
 		select {
 		case message := <-c.incomingMessages:
 			// Process incoming message
+			c.statsMu.Lock()
 			c.stats.MessagesReceived++
+			c.statsMu.Unlock()
 			_ = message // Use the message variable
 		case <-c.ctx.Done():
 			return
@@ -465,6 +475,8 @@ func (c *Client) processOutgoingMessages() {
 		case message := <-c.outgoingMessages:
 			if err := c.sendMessage(message); err != nil {
 				c.logger.Error("Failed to send message", "error", err)
+			} else if message.QoS == QoS0 && message.ack != nil {
+				message.ack()
 			}
 		case <-c.ctx.Done():
 			return
@@ -480,7 +492,10 @@ func (c *Client) handleKeepAlive() {
 	for {
 		select {
 		case <-ticker.C:
-			if time.Since(c.stats.LastActivity) > c.keepAlive {
+			c.statsMu.Lock()
+			idle := time.Since(c.stats.LastActivity)
+			c.statsMu.Unlock()
+			if idle > c.keepAlive {
 				c.logger.Info("Client keep-alive timeout", "clientId", c.ID)
 				c.Close()
 				return
@@ -504,6 +519,11 @@ func (c *Client) sendMessage(message *Message) error {
 	// Set packet ID for QoS > 0
 	if message.QoS > QoS0 {
 		publish.PacketID = c.generatePacketID()
+		if message.ack != nil {
+			c.pendingMu.Lock()
+			c.deliveries[publish.PacketID] = message.ack
+			c.pendingMu.Unlock()
+		}
 	}
 
 	// Send packet
@@ -526,17 +546,36 @@ func (c *Client) sendPacket(packet *Packet) error {
 		return err
 	}
 
+	c.statsMu.Lock()
 	c.stats.BytesSent += int64(len(data))
 	c.stats.MessagesSent++
 	c.stats.LastActivity = time.Now()
+	c.statsMu.Unlock()
 
 	return nil
 }
 
-// generatePacketID generates a unique packet ID
+// generatePacketID generates a packet ID no delivery is waiting on
 func (c *Client) generatePacketID() uint16 {
-	// Simple implementation - in production, should track used IDs
-	return uint16(time.Now().UnixNano() & 0xFFFF)
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	for {
+		c.lastPacketID++
+		if _, used := c.deliveries[c.lastPacketID]; c.lastPacketID != 0 && !used {
+			return c.lastPacketID
+		}
+	}
+}
+
+// delivered runs the acknowledgment of the message sent with a packet ID
+func (c *Client) delivered(packetID uint16) {
+	c.pendingMu.Lock()
+	ack := c.deliveries[packetID]
+	delete(c.deliveries, packetID)
+	c.pendingMu.Unlock()
+	if ack != nil {
+		ack()
+	}
 }
 
 // addSubscription adds a subscription
@@ -578,201 +617,6 @@ func (c *Client) Close() {
 	}
 }
 
-// Packet parsing methods
-
-// parseConnectPacket parses a CONNECT packet
-func (c *Client) parseConnectPacket(packet *Packet) (*ConnectPacket, error) {
-	data := packet.Data
-	offset := 0
-
-	// Protocol name
-	protocolName, offset, err := readString(data, offset)
-	if err != nil {
-		return nil, err
-	}
-
-	// Protocol level
-	protocolLevel, offset, err := readByte(data, offset)
-	if err != nil {
-		return nil, err
-	}
-
-	// Connect flags
-	connectFlags, offset, err := readByte(data, offset)
-	if err != nil {
-		return nil, err
-	}
-
-	// Keep alive
-	keepAlive, offset, err := readUint16(data, offset)
-	if err != nil {
-		return nil, err
-	}
-
-	// Client ID
-	clientID, offset, err := readString(data, offset)
-	if err != nil {
-		return nil, err
-	}
-
-	connect := &ConnectPacket{
-		ProtocolName:  protocolName,
-		ProtocolLevel: protocolLevel,
-		ConnectFlags:  connectFlags,
-		KeepAlive:     keepAlive,
-		ClientID:      clientID,
-		CleanSession:  (connectFlags & 0x02) != 0,
-		WillFlag:      (connectFlags & 0x04) != 0,
-		WillQoS:       (connectFlags & 0x18) >> 3,
-		WillRetain:    (connectFlags & 0x20) != 0,
-		PasswordFlag:  (connectFlags & 0x40) != 0,
-		UsernameFlag:  (connectFlags & 0x80) != 0,
-	}
-
-	// Will topic and message
-	if connect.WillFlag {
-		connect.WillTopic, offset, err = readString(data, offset)
-		if err != nil {
-			return nil, err
-		}
-
-		willMessageLen, offset, err := readUint16(data, offset)
-		if err != nil {
-			return nil, err
-		}
-
-		if offset+int(willMessageLen) <= len(data) {
-			connect.WillMessage = data[offset : offset+int(willMessageLen)]
-		}
-		// Update offset for next field parsing
-		offset += int(willMessageLen)
-		_ = offset // Ensure offset is used
-	}
-
-	// Username
-	if connect.UsernameFlag {
-		connect.Username, offset, err = readString(data, offset)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	// Password
-	if connect.PasswordFlag {
-		passwordLen, offset, err := readUint16(data, offset)
-		if err != nil {
-			return nil, err
-		}
-
-		if offset+int(passwordLen) <= len(data) {
-			connect.Password = data[offset : offset+int(passwordLen)]
-		}
-	}
-
-	return connect, nil
-}
-
-// parsePublishPacket parses a PUBLISH packet
-func (c *Client) parsePublishPacket(packet *Packet) (*PublishPacket, error) {
-	data := packet.Data
-	offset := 0
-
-	// Topic
-	topic, offset, err := readString(data, offset)
-	if err != nil {
-		return nil, err
-	}
-
-	publish := &PublishPacket{
-		Topic:  topic,
-		QoS:    QoS((packet.Header.Flags & 0x06) >> 1),
-		Retain: (packet.Header.Flags & 0x01) != 0,
-		Dup:    (packet.Header.Flags & 0x08) != 0,
-	}
-
-	// Packet ID for QoS > 0
-	if publish.QoS > QoS0 {
-		publish.PacketID, offset, err = readUint16(data, offset)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	// Payload
-	if offset < len(data) {
-		publish.Payload = data[offset:]
-	}
-
-	return publish, nil
-}
-
-// parseSubscribePacket parses a SUBSCRIBE packet
-func (c *Client) parseSubscribePacket(packet *Packet) (*SubscribePacket, error) {
-	data := packet.Data
-	offset := 0
-
-	// Packet ID
-	packetID, offset, err := readUint16(data, offset)
-	if err != nil {
-		return nil, err
-	}
-
-	subscribe := &SubscribePacket{
-		PacketID: packetID,
-	}
-
-	// Subscriptions
-	for offset < len(data) {
-		topic, newOffset, err := readString(data, offset)
-		if err != nil {
-			break
-		}
-		offset = newOffset
-
-		qos, newOffset, err := readByte(data, offset)
-		if err != nil {
-			break
-		}
-		offset = newOffset
-
-		subscribe.Subscriptions = append(subscribe.Subscriptions, Subscription{
-			Topic: topic,
-			QoS:   QoS(qos),
-		})
-	}
-
-	return subscribe, nil
-}
-
-// parseUnsubscribePacket parses an UNSUBSCRIBE packet
-func (c *Client) parseUnsubscribePacket(packet *Packet) (*UnsubscribePacket, error) {
-	data := packet.Data
-	offset := 0
-
-	// Packet ID
-	packetID, offset, err := readUint16(data, offset)
-	if err != nil {
-		return nil, err
-	}
-
-	unsubscribe := &UnsubscribePacket{
-		PacketID: packetID,
-	}
-
-	// Topics
-	for offset < len(data) {
-		topic, newOffset, err := readString(data, offset)
-		if err != nil {
-			break
-		}
-		offset = newOffset
-
-		unsubscribe.Topics = append(unsubscribe.Topics, topic)
-	}
-
-	return unsubscribe, nil
-}
-
 // Packet handling methods
 
 // handleConnack handles a CONNACK packet (client side)
@@ -793,6 +637,7 @@ func (c *Client) handlePuback(packet *Packet) error {
 	c.pendingMu.Lock()
 	delete(c.pendingPublishes, packetID)
 	c.pendingMu.Unlock()
+	c.delivered(packetID)
 
 	c.logger.Debug("Received PUBACK", "packetId", packetID)
 	return nil
@@ -805,6 +650,7 @@ func (c *Client) handlePubrec(packet *Packet) error {
 	}
 
 	packetID := binary.BigEndian.Uint16(packet.Data[0:2])
+	c.delivered(packetID)
 
 	// Send PUBREL
 	if err := c.sendPubrel(packetID); err != nil {
@@ -828,12 +674,15 @@ func (c *Client) handlePubrel(packet *Packet) error {
 		return err
 	}
 
-	// Process the stored QoS 2 message
-	if message := c.broker.messageStore.GetQoS2Message(packetID); message != nil {
-		if err := c.broker.publishMessage(message); err != nil {
+	// Process the QoS 2 message stored on PUBLISH
+	c.pendingMu.Lock()
+	pending := c.pendingPublishes[packetID]
+	delete(c.pendingPublishes, packetID)
+	c.pendingMu.Unlock()
+	if pending != nil {
+		if err := c.broker.publishMessage(pending.Message); err != nil {
 			c.logger.Error("Failed to publish QoS 2 message", "error", err, "packetId", packetID)
 		}
-		c.broker.messageStore.RemoveQoS2Message(packetID)
 	}
 
 	c.logger.Debug("Received PUBREL", "packetId", packetID)
@@ -0,0 +1,93 @@
+package mqtt
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/pubsub"
+)
+
+// Durable topics are the topics the node keeps in logs, see
+// fileserver.Server.Topics. Messages published to them are appended to
+// their log instead of being handed to subscribers, and each subscription
+// follows the log, so subscribers also get the messages published over the
+// node's other APIs. Clients with a persistent session (clean session off)
+// consume as the consumer group named by their client ID: they resume
+// after the last message they acknowledged, with PUBACK for QoS 1, PUBREC
+// for QoS 2 and by being sent for QoS 0. Clients with a clean session start
+// at the end of the topic.
+
+// durableBatch is the most messages read from a log at once
+const durableBatch = 100
+
+// durableTopics returns the durable topics of the node when topic is one
+func (b *Broker) durableTopics(topic string) *pubsub.Topics {
+	if b.fileserver == nil {
+		return nil
+	}
+	if topics := b.fileserver.Topics(); topics != nil && topics.Durable(topic) {
+		return topics
+	}
+	return nil
+}
+
+// followDurable delivers the messages of a durable topic to the client
+// until it unsubscribes or disconnects
+func (c *Client) followDurable(topics *pubsub.Topics, topic string, qos QoS) error {
+	group := ""
+	if !c.cleanSession {
+		group = c.ID
+	}
+	sub, err := topics.Subscribe(c.ctx, topic, group, pubsub.Resume)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.durableMu.Lock()
+	if stop := c.durable[topic]; stop != nil {
+		stop()
+	}
+	c.durable[topic] = cancel
+	c.durableMu.Unlock()
+
+	go func() {
+		for {
+			messages, err := sub.Next(ctx, durableBatch)
+			if err != nil {
+				if ctx.Err() == nil {
+					c.logger.Error("Failed to read durable topic", "error", err, "topic", topic, "clientId", c.ID)
+				}
+				return
+			}
+			for _, m := range messages {
+				offset := m.Offset
+				message := &Message{
+					Topic:   topic,
+					Payload: m.Payload,
+					QoS:     qos,
+					ack: func() {
+						if err := sub.Ack(context.Background(), offset); err != nil {
+							c.logger.Error("Failed to acknowledge durable message", "error", err, "topic", topic, "offset", offset)
+						}
+					},
+				}
+				select {
+				case c.outgoingMessages <- message:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// stopDurable stops following a durable topic
+func (c *Client) stopDurable(topic string) {
+	c.durableMu.Lock()
+	defer c.durableMu.Unlock()
+	if stop := c.durable[topic]; stop != nil {
+		stop()
+		delete(c.durable, topic)
+	}
+}
@@ -0,0 +1,111 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/firmware"
+)
+
+// Devices reach the firmware distribution under firmware.TopicPrefix and
+// their device ID, so only clients with a device certificate can. A device
+// subscribes to firmware/{device}/manifest and firmware/{device}/block,
+// then publishes:
+//
+//   - firmware/{device}/check, with the version it runs, answered on
+//     manifest with the JSON firmware.Manifest offered, or nothing when no
+//     update is
+//   - firmware/{device}/get, with {"image_id": ..., "block": n}, answered
+//     on block with the 4-byte block number followed by up to
+//     firmware.MQTTBlockSize bytes of the image
+//   - firmware/{device}/status, with a JSON firmware.StatusReport
+//
+// Manifests are also sent to the devices a rollout releases an update to
+// while they are subscribed.
+
+// blockRequest asks for a block of an image
+type blockRequest struct {
+	ImageID string `json:"image_id"`
+	Block   uint32 `json:"block"`
+}
+
+// handleFirmware answers a device publishing to its firmware topics.
+// Clients publishing to the topics of other devices, or to those the
+// broker answers on, are disconnected.
+func (c *Client) handleFirmware(topic string, payload []byte) error {
+	device, action, _ := strings.Cut(strings.TrimPrefix(topic, firmware.TopicPrefix), "/")
+	if c.DeviceID == "" || device != c.DeviceID {
+		return fmt.Errorf("client %s may not publish to %s", c.ID, topic)
+	}
+	manager := c.broker.config.Firmware
+	ctx := context.Background()
+	switch action {
+	case "check":
+		manifest, err := manager.Check(ctx, device, string(payload))
+		switch {
+		case errors.Is(err, firmware.ErrNoUpdate):
+			return c.broker.publishFirmware(device, "manifest", nil)
+		case errors.Is(err, firmware.ErrDenied):
+			return err
+		case err != nil:
+			c.logger.Warn("Firmware check failed", "device", device, "error", err)
+			return nil
+		}
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+		return c.broker.publishFirmware(device, "manifest", data)
+	case "get":
+		var req blockRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			c.logger.Warn("Invalid firmware block request", "device", device, "error", err)
+			return nil
+		}
+		data, err := manager.Content(ctx, req.ImageID)
+		if err != nil {
+			c.logger.Warn("Firmware block request failed", "device", device, "image", req.ImageID, "error", err)
+			return nil
+		}
+		start := int64(req.Block) * firmware.MQTTBlockSize
+		if start >= int64(len(data)) {
+			c.logger.Warn("Firmware block out of range", "device", device, "image", req.ImageID, "block", req.Block)
+			return nil
+		}
+		end := min(start+firmware.MQTTBlockSize, int64(len(data)))
+		block := binary.BigEndian.AppendUint32(make([]byte, 0, 4+end-start), req.Block)
+		return c.broker.publishFirmware(device, "block", append(block, data[start:end]...))
+	case "status":
+		var report firmware.StatusReport
+		if err := json.Unmarshal(payload, &report); err != nil {
+			c.logger.Warn("Invalid firmware status", "device", device, "error", err)
+			return nil
+		}
+		if _, err := manager.Report(ctx, device, report); err != nil {
+			c.logger.Warn("Firmware status refused", "device", device, "error", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("client %s may not publish to %s", c.ID, topic)
+	}
+}
+
+// announceFirmware sends a device the update a rollout released to it
+func (b *Broker) announceFirmware(manifest firmware.Manifest) {
+	data, err := json.Marshal(manifest)
+	if err == nil {
+		err = b.publishFirmware(manifest.Device, "manifest", data)
+	}
+	if err != nil {
+		b.logger.Warn("Failed to announce firmware", "device", manifest.Device, "error", err)
+	}
+}
+
+// publishFirmware publishes to one of the topics devices get firmware on
+func (b *Broker) publishFirmware(device, topic string, payload []byte) error {
+	return b.publishMessage(&Message{Topic: firmware.TopicPrefix + device + "/" + topic, Payload: payload, QoS: QoS1})
+}
@@ -1,8 +1,13 @@
 package mqtt
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
 )
 
 // MessageType represents the MQTT message type
@@ -159,6 +164,10 @@ type Message struct {
 	Payload []byte
 	QoS     QoS
 	Retain  bool
+
+	// ack, when set, is called once the client has the message: when it is
+	// sent for QoS 0, on PUBACK for QoS 1 and on PUBREC for QoS 2
+	ack func()
 }
 
 // Encode encodes a packet to bytes
@@ -170,6 +179,9 @@ func (p *Packet) Encode() ([]byte, error) {
 	switch p.Header.MessageType {
 	case CONNACK:
 		data, err = p.encodeConnack()
+	case PUBLISH:
+		// Built by createPublishPacket
+		data = p.Data
 	case PUBACK:
 		data, err = p.encodePuback()
 	case PUBREC:
@@ -299,25 +311,381 @@ func (p *Packet) encodePingresp() ([]byte, error) {
 	return []byte{}, nil
 }
 
-// Helper functions for reading MQTT data
+// Packet parsing. Packets come from the network, so every length is
+// checked against the data at hand and packets breaking the MQTT 3.1.1
+// rules fail with ErrMalformedPacket rather than being read leniently.
 
-// readString reads an MQTT string (2-byte length + string)
-func readString(data []byte, offset int) (string, int, error) {
-	if offset+2 > len(data) {
-		return "", offset, fmt.Errorf("insufficient data for string length")
+// ErrMalformedPacket is wrapped by the errors of packets that break the
+// MQTT rules, as opposed to failed reads
+var ErrMalformedPacket = errors.New("malformed MQTT packet")
+
+const (
+	// MaxRemainingLength is the largest remaining length the four bytes
+	// of the fixed header can encode
+	MaxRemainingLength = 268435455
+
+	// packetChunkSize is the memory reserved for a packet up front
+	packetChunkSize = 64 * 1024
+)
+
+// ReadPacket reads a packet from r. Packets longer than maxSize bytes are
+// refused before they are read; zero or less allows MaxRemainingLength.
+// A connection closed before the packet starts returns io.EOF.
+func ReadPacket(r io.Reader, maxSize int) (*Packet, error) {
+	var first [1]byte
+	if _, err := io.ReadFull(r, first[:]); err != nil {
+		return nil, err
+	}
+	header := &FixedHeader{
+		MessageType: MessageType(first[0] >> 4),
+		Flags:       first[0] & 0x0F,
+	}
+	if err := validateFixedHeader(header); err != nil {
+		return nil, err
 	}
 
-	length := int(binary.BigEndian.Uint16(data[offset : offset+2]))
-	offset += 2
+	remainingLength, err := readRemainingLength(r)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize <= 0 || maxSize > MaxRemainingLength {
+		maxSize = MaxRemainingLength
+	}
+	if remainingLength > maxSize {
+		return nil, fmt.Errorf("%w: packet too large: %d bytes (max: %d)", ErrMalformedPacket, remainingLength, maxSize)
+	}
 
-	if offset+length > len(data) {
-		return "", offset, fmt.Errorf("insufficient data for string content")
+	packet := &Packet{Header: header}
+	if remainingLength > 0 {
+		// The buffer grows as the packet arrives, so a client announcing
+		// a large packet without sending it holds no memory
+		buf := bytes.NewBuffer(make([]byte, 0, min(remainingLength, packetChunkSize)))
+		if _, err := io.CopyN(buf, r, int64(remainingLength)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		packet.Data = buf.Bytes()
 	}
 
-	str := string(data[offset : offset+length])
-	offset += length
+	return packet, nil
+}
 
-	return str, offset, nil
+// readRemainingLength reads the variable length encoding of the remaining
+// length, at most four bytes long
+func readRemainingLength(r io.Reader) (int, error) {
+	var encoded [1]byte
+	value, multiplier := 0, 1
+	for i := 0; i < 4; i++ {
+		if _, err := io.ReadFull(r, encoded[:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		value += int(encoded[0]&127) * multiplier
+		if encoded[0]&128 == 0 {
+			return value, nil
+		}
+		multiplier *= 128
+	}
+	return 0, fmt.Errorf("%w: remaining length longer than 4 bytes", ErrMalformedPacket)
+}
+
+// validateFixedHeader checks the type and flags of a fixed header
+func validateFixedHeader(header *FixedHeader) error {
+	switch header.MessageType {
+	case RESERVED, 15:
+		return fmt.Errorf("%w: reserved message type %d", ErrMalformedPacket, header.MessageType)
+	case PUBLISH:
+		if QoS((header.Flags&0x06)>>1) > QoS2 {
+			return fmt.Errorf("%w: PUBLISH with QoS 3", ErrMalformedPacket)
+		}
+	case PUBREL, SUBSCRIBE, UNSUBSCRIBE:
+		if header.Flags != 0x02 {
+			return fmt.Errorf("%w: flags %#x of message type %d", ErrMalformedPacket, header.Flags, header.MessageType)
+		}
+	default:
+		if header.Flags != 0 {
+			return fmt.Errorf("%w: flags %#x of message type %d", ErrMalformedPacket, header.Flags, header.MessageType)
+		}
+	}
+	return nil
+}
+
+// ParseConnect parses a CONNECT packet
+func ParseConnect(packet *Packet) (*ConnectPacket, error) {
+	data := packet.Data
+	offset := 0
+
+	// Protocol name
+	protocolName, offset, err := readString(data, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	// Protocol level
+	protocolLevel, offset, err := readByte(data, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	// Connect flags
+	connectFlags, offset, err := readByte(data, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep alive
+	keepAlive, offset, err := readUint16(data, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	// Client ID
+	clientID, offset, err := readString(data, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	connect := &ConnectPacket{
+		ProtocolName:  protocolName,
+		ProtocolLevel: protocolLevel,
+		ConnectFlags:  connectFlags,
+		KeepAlive:     keepAlive,
+		ClientID:      clientID,
+		CleanSession:  (connectFlags & 0x02) != 0,
+		WillFlag:      (connectFlags & 0x04) != 0,
+		WillQoS:       (connectFlags & 0x18) >> 3,
+		WillRetain:    (connectFlags & 0x20) != 0,
+		PasswordFlag:  (connectFlags & 0x40) != 0,
+		UsernameFlag:  (connectFlags & 0x80) != 0,
+	}
+
+	switch {
+	case connectFlags&0x01 != 0:
+		return nil, fmt.Errorf("%w: reserved connect flag set", ErrMalformedPacket)
+	case connect.WillQoS > byte(QoS2):
+		return nil, fmt.Errorf("%w: will with QoS 3", ErrMalformedPacket)
+	case !connect.WillFlag && (connect.WillQoS != 0 || connect.WillRetain):
+		return nil, fmt.Errorf("%w: will QoS or retain without a will", ErrMalformedPacket)
+	case connect.PasswordFlag && !connect.UsernameFlag:
+		return nil, fmt.Errorf("%w: password without a user name", ErrMalformedPacket)
+	}
+
+	// Will topic and message
+	if connect.WillFlag {
+		connect.WillTopic, offset, err = readString(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		if !validTopicName(connect.WillTopic) {
+			return nil, fmt.Errorf("%w: invalid will topic %q", ErrMalformedPacket, connect.WillTopic)
+		}
+		connect.WillMessage, offset, err = readBinary(data, offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Username
+	if connect.UsernameFlag {
+		connect.Username, offset, err = readString(data, offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Password
+	if connect.PasswordFlag {
+		connect.Password, offset, err = readBinary(data, offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if offset != len(data) {
+		return nil, fmt.Errorf("%w: %d trailing bytes in CONNECT", ErrMalformedPacket, len(data)-offset)
+	}
+
+	return connect, nil
+}
+
+// ParsePublish parses a PUBLISH packet
+func ParsePublish(packet *Packet) (*PublishPacket, error) {
+	data := packet.Data
+	offset := 0
+
+	// Topic
+	topic, offset, err := readString(data, offset)
+	if err != nil {
+		return nil, err
+	}
+	if !validTopicName(topic) {
+		return nil, fmt.Errorf("%w: invalid topic %q", ErrMalformedPacket, topic)
+	}
+
+	publish := &PublishPacket{
+		Topic:  topic,
+		QoS:    QoS((packet.Header.Flags & 0x06) >> 1),
+		Retain: (packet.Header.Flags & 0x01) != 0,
+		Dup:    (packet.Header.Flags & 0x08) != 0,
+	}
+
+	switch {
+	case publish.QoS > QoS2:
+		return nil, fmt.Errorf("%w: PUBLISH with QoS 3", ErrMalformedPacket)
+	case publish.QoS == QoS0 && publish.Dup:
+		return nil, fmt.Errorf("%w: DUP set on a QoS 0 PUBLISH", ErrMalformedPacket)
+	}
+
+	// Packet ID for QoS > 0
+	if publish.QoS > QoS0 {
+		publish.PacketID, offset, err = readPacketID(data, offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Payload
+	if offset < len(data) {
+		publish.Payload = data[offset:]
+	}
+
+	return publish, nil
+}
+
+// ParseSubscribe parses a SUBSCRIBE packet
+func ParseSubscribe(packet *Packet) (*SubscribePacket, error) {
+	data := packet.Data
+	offset := 0
+
+	// Packet ID
+	packetID, offset, err := readPacketID(data, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	subscribe := &SubscribePacket{
+		PacketID: packetID,
+	}
+
+	// Subscriptions
+	for offset < len(data) {
+		var topic string
+		topic, offset, err = readString(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		if !validTopicFilter(topic) {
+			return nil, fmt.Errorf("%w: invalid topic filter %q", ErrMalformedPacket, topic)
+		}
+
+		var qos byte
+		qos, offset, err = readByte(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		if qos > byte(QoS2) {
+			return nil, fmt.Errorf("%w: requested QoS byte %#x", ErrMalformedPacket, qos)
+		}
+
+		subscribe.Subscriptions = append(subscribe.Subscriptions, Subscription{
+			Topic: topic,
+			QoS:   QoS(qos),
+		})
+	}
+	if len(subscribe.Subscriptions) == 0 {
+		return nil, fmt.Errorf("%w: SUBSCRIBE without topic filters", ErrMalformedPacket)
+	}
+
+	return subscribe, nil
+}
+
+// ParseUnsubscribe parses an UNSUBSCRIBE packet
+func ParseUnsubscribe(packet *Packet) (*UnsubscribePacket, error) {
+	data := packet.Data
+	offset := 0
+
+	// Packet ID
+	packetID, offset, err := readPacketID(data, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	unsubscribe := &UnsubscribePacket{
+		PacketID: packetID,
+	}
+
+	// Topics
+	for offset < len(data) {
+		var topic string
+		topic, offset, err = readString(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		if !validTopicFilter(topic) {
+			return nil, fmt.Errorf("%w: invalid topic filter %q", ErrMalformedPacket, topic)
+		}
+
+		unsubscribe.Topics = append(unsubscribe.Topics, topic)
+	}
+	if len(unsubscribe.Topics) == 0 {
+		return nil, fmt.Errorf("%w: UNSUBSCRIBE without topic filters", ErrMalformedPacket)
+	}
+
+	return unsubscribe, nil
+}
+
+// validTopicName reports whether name may be published to: topic names
+// are not empty and hold no wildcards
+func validTopicName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "+#")
+}
+
+// validTopicFilter reports whether filter may be subscribed to: wildcards
+// take up whole levels, and # only the last one
+func validTopicFilter(filter string) bool {
+	if filter == "" {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		switch {
+		case level == "#" && i == len(levels)-1, level == "+":
+		case strings.ContainsAny(level, "+#"):
+			return false
+		}
+	}
+	return true
+}
+
+// Helper functions for reading MQTT data
+
+// readString reads an MQTT string (2-byte length + string); strings are
+// UTF-8 without U+0000
+func readString(data []byte, offset int) (string, int, error) {
+	raw, offset, err := readBinary(data, offset)
+	if err != nil {
+		return "", offset, err
+	}
+	if !utf8.Valid(raw) || bytes.IndexByte(raw, 0) >= 0 {
+		return "", offset, fmt.Errorf("%w: invalid UTF-8 string", ErrMalformedPacket)
+	}
+	return string(raw), offset, nil
+}
+
+// readBinary reads MQTT binary data (2-byte length + bytes)
+func readBinary(data []byte, offset int) ([]byte, int, error) {
+	length, offset, err := readUint16(data, offset)
+	if err != nil {
+		return nil, offset, err
+	}
+	if offset+int(length) > len(data) {
+		return nil, offset, fmt.Errorf("%w: insufficient data for %d bytes", ErrMalformedPacket, length)
+	}
+	return data[offset : offset+int(length)], offset + int(length), nil
 }
 
 // writeString writes an MQTT string (2-byte length + string)
@@ -333,7 +701,7 @@ func writeString(data []byte, offset int, str string) int {
 // readUint16 reads a 16-bit unsigned integer
 func readUint16(data []byte, offset int) (uint16, int, error) {
 	if offset+2 > len(data) {
-		return 0, offset, fmt.Errorf("insufficient data for uint16")
+		return 0, offset, fmt.Errorf("%w: insufficient data for uint16", ErrMalformedPacket)
 	}
 
 	value := binary.BigEndian.Uint16(data[offset : offset+2])
@@ -342,6 +710,15 @@ func readUint16(data []byte, offset int) (uint16, int, error) {
 	return value, offset, nil
 }
 
+// readPacketID reads a packet identifier, which is never zero
+func readPacketID(data []byte, offset int) (uint16, int, error) {
+	id, offset, err := readUint16(data, offset)
+	if err == nil && id == 0 {
+		err = fmt.Errorf("%w: packet identifier 0", ErrMalformedPacket)
+	}
+	return id, offset, err
+}
+
 // writeUint16 writes a 16-bit unsigned integer
 func writeUint16(data []byte, offset int, value uint16) int {
 	binary.BigEndian.PutUint16(data[offset:offset+2], value)
@@ -351,7 +728,7 @@ func writeUint16(data []byte, offset int, value uint16) int {
 // readByte reads a single byte
 func readByte(data []byte, offset int) (byte, int, error) {
 	if offset >= len(data) {
-		return 0, offset, fmt.Errorf("insufficient data for byte")
+		return 0, offset, fmt.Errorf("%w: insufficient data for byte", ErrMalformedPacket)
 	}
 
 	value := data[offset]
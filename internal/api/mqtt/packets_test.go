@@ -0,0 +1,121 @@
+package mqtt
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadPacket(t *testing.T) {
+	packet, err := ReadPacket(bytes.NewReader(connectPacket), 0)
+	require.NoError(t, err)
+	assert.Equal(t, CONNECT, packet.Header.MessageType)
+	assert.Equal(t, connectPacket[2:], packet.Data)
+
+	connect, err := ParseConnect(packet)
+	require.NoError(t, err)
+	assert.Equal(t, "MQTT", connect.ProtocolName)
+	assert.Equal(t, "c1", connect.ClientID)
+	assert.True(t, connect.CleanSession)
+
+	// The longest remaining length takes four bytes
+	packet, err = ReadPacket(io.MultiReader(
+		bytes.NewReader([]byte{0x30, 0x80, 0x80, 0x80, 0x01}),
+		bytes.NewReader(append(mqttString("t"), make([]byte, 128*128*128-3)...)),
+	), 0)
+	require.NoError(t, err)
+	assert.Len(t, packet.Data, 128*128*128)
+
+	_, err = ReadPacket(bytes.NewReader(nil), 0)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReadPacketRejectsMalformed(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"reserved type":           {0x00, 0},
+		"type 15":                 {0xF0, 0},
+		"PUBLISH with QoS 3":      {0x36, 0},
+		"SUBSCRIBE without 0x2":   {0x80, 0},
+		"PUBREL without 0x2":      {0x60, 2, 0, 1},
+		"PINGREQ with flags":      {0xC1, 0},
+		"5-byte remaining length": {0x30, 0x80, 0x80, 0x80, 0x80, 0x01},
+		"above the maximum size":  {0x30, 0x81, 0x08},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ReadPacket(bytes.NewReader(data), 1024)
+			assert.ErrorIs(t, err, ErrMalformedPacket)
+		})
+	}
+
+	// Truncated packets are failed reads
+	_, err := ReadPacket(bytes.NewReader([]byte{0x30, 10, 0, 1}), 0)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, err = ReadPacket(bytes.NewReader([]byte{0x30, 0x80}), 0)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestParsePacketsRejectMalformed(t *testing.T) {
+	connect := func(flags byte, rest ...byte) *Packet {
+		data := append([]byte{0, 4, 'M', 'Q', 'T', 'T', 4, flags, 0, 60, 0, 2, 'c', '1'}, rest...)
+		return &Packet{Header: &FixedHeader{MessageType: CONNECT}, Data: data}
+	}
+	publish := func(flags byte, data []byte) *Packet {
+		return &Packet{Header: &FixedHeader{MessageType: PUBLISH, Flags: flags}, Data: data}
+	}
+	subscribe := func(data ...byte) *Packet {
+		return &Packet{Header: &FixedHeader{MessageType: SUBSCRIBE, Flags: 0x02}, Data: data}
+	}
+	unsubscribe := func(data ...byte) *Packet {
+		return &Packet{Header: &FixedHeader{MessageType: UNSUBSCRIBE, Flags: 0x02}, Data: data}
+	}
+	parse := map[MessageType]func(*Packet) error{
+		CONNECT:     func(p *Packet) error { _, err := ParseConnect(p); return err },
+		PUBLISH:     func(p *Packet) error { _, err := ParsePublish(p); return err },
+		SUBSCRIBE:   func(p *Packet) error { _, err := ParseSubscribe(p); return err },
+		UNSUBSCRIBE: func(p *Packet) error { _, err := ParseUnsubscribe(p); return err },
+	}
+
+	for name, packet := range map[string]*Packet{
+		"reserved connect flag":      connect(0x03),
+		"will QoS without will":      connect(0x0A),
+		"will with QoS 3":            connect(0x1E, 0, 1, 't', 0, 0),
+		"password without username":  connect(0x42, 0, 1, 'p'),
+		"truncated will message":     connect(0x06, 0, 1, 't', 0, 5, 'x'),
+		"truncated password":         connect(0xC2, 0, 1, 'u', 0, 5, 'p'),
+		"trailing bytes":             connect(0x02, 0xFF),
+		"truncated client ID":        {Header: &FixedHeader{MessageType: CONNECT}, Data: []byte{0, 4, 'M', 'Q', 'T', 'T', 4, 2, 0, 60, 0, 9}},
+		"wildcard topic":             publish(0, mqttString("a/+")),
+		"empty topic":                publish(0, mqttString("")),
+		"topic with U+0000":          publish(0, mqttString("a\x00b")),
+		"topic with invalid UTF-8":   publish(0, mqttString("a\xffb")),
+		"publish QoS 3":              publish(0x06, append(mqttString("a"), 0, 1)),
+		"DUP on QoS 0":               publish(0x08, mqttString("a")),
+		"packet ID 0":                publish(0x02, append(mqttString("a"), 0, 0)),
+		"missing packet ID":          publish(0x02, mqttString("a")),
+		"subscribe without filters":  subscribe(0, 1),
+		"subscribe packet ID 0":      subscribe(append([]byte{0, 0}, append(mqttString("a"), 0)...)...),
+		"subscribe missing QoS":      subscribe(append([]byte{0, 1}, mqttString("a")...)...),
+		"subscribe reserved QoS":     subscribe(append([]byte{0, 1}, append(mqttString("a"), 0x04)...)...),
+		"subscribe # not last":       subscribe(append([]byte{0, 1}, append(mqttString("a/#/b"), 0)...)...),
+		"subscribe partial +":        subscribe(append([]byte{0, 1}, append(mqttString("a/b+"), 0)...)...),
+		"truncated filter":           subscribe(0, 1, 0, 5, 'a'),
+		"unsubscribe without topics": unsubscribe(0, 1),
+		"truncated unsubscribe":      unsubscribe(0, 1, 0, 5, 'a'),
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, parse[packet.Header.MessageType](packet), ErrMalformedPacket)
+		})
+	}
+}
+
+func TestParseSubscribe(t *testing.T) {
+	data := append([]byte{0, 7}, append(mqttString("a/+/c"), 1)...)
+	data = append(data, append(mqttString("#"), 2)...)
+	subscribe, err := ParseSubscribe(&Packet{Header: &FixedHeader{MessageType: SUBSCRIBE, Flags: 0x02}, Data: data})
+	require.NoError(t, err)
+	assert.Equal(t, uint16(7), subscribe.PacketID)
+	assert.Equal(t, []Subscription{{Topic: "a/+/c", QoS: QoS1}, {Topic: "#", QoS: QoS2}}, subscribe.Subscriptions)
+}
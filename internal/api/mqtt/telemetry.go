@@ -0,0 +1,39 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/telemetry"
+)
+
+// recordTelemetry records the readings a device publishes under
+// telemetry.TopicPrefix and its device ID, either as a JSON object of
+// metric names and numbers to telemetry/{device}, or as a number to
+// telemetry/{device}/{metric}. The messages are delivered to subscribers
+// like any other; only those of clients whose certificate names the
+// device are recorded.
+func (c *Client) recordTelemetry(topic string, payload []byte) {
+	device, metric, hasMetric := strings.Cut(strings.TrimPrefix(topic, telemetry.TopicPrefix), "/")
+	if c.DeviceID == "" || device != c.DeviceID {
+		return
+	}
+	readings := make(map[string]float64)
+	if hasMetric {
+		value, err := strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+		if err != nil {
+			c.logger.Debug("Invalid telemetry reading", "device", device, "topic", topic, "error", err)
+			return
+		}
+		readings[metric] = value
+	} else if err := json.Unmarshal(payload, &readings); err != nil {
+		c.logger.Debug("Invalid telemetry readings", "device", device, "topic", topic, "error", err)
+		return
+	}
+	for metric, value := range readings {
+		if err := c.broker.config.Telemetry.Record(telemetry.Reading{Device: device, Metric: metric, Value: value}); err != nil {
+			c.logger.Debug("Dropped telemetry reading", "device", device, "metric", metric, "error", err)
+		}
+	}
+}
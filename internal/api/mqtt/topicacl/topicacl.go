@@ -0,0 +1,500 @@
+// Package topicacl decides which MQTT topics a client may publish to and
+// subscribe to. Rules allow or deny a topic filter to devices by ID or
+// tenant, and a filter may name the client's own device and tenant, as in
+// devices/{device}/#, so one rule confines every device to its own topics.
+// A compromised device can then neither read nor flood the topics of the
+// others, and every denial is recorded in the audit log.
+package topicacl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/Skpow1234/Peervault/internal/audit"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+)
+
+var (
+	// ErrNotFound is returned for rules that do not exist
+	ErrNotFound = errors.New("topicacl: not found")
+	// ErrInvalid is returned for malformed rules
+	ErrInvalid = errors.New("topicacl: invalid rule")
+	// ErrReadOnly is returned when removing a rule of the configuration
+	ErrReadOnly = errors.New("topicacl: rule comes from the configuration")
+	// ErrDenied is returned for topics the rules keep a client from
+	ErrDenied = errors.New("topicacl: topic denied")
+)
+
+// Action is what a rule does with the topics it matches
+type Action string
+
+const (
+	Allow Action = "allow"
+	Deny  Action = "deny"
+)
+
+// Access is what a client does with a topic
+type Access string
+
+const (
+	Publish   Access = "publish"
+	Subscribe Access = "subscribe"
+)
+
+// Placeholders of rule topics, replaced by the client's identity. Rules
+// naming one do not apply to clients without it.
+const (
+	DevicePlaceholder = "{device}"
+	TenantPlaceholder = "{tenant}"
+)
+
+// Rule allows or denies the topics within Topic to the clients matching
+// every criterion it sets
+type Rule struct {
+	ID     string `json:"id" yaml:"id"`
+	Action Action `json:"action" yaml:"action"`
+	// Access is publish or subscribe; empty applies to both
+	Access Access `json:"access,omitempty" yaml:"access"`
+	// Topic is an MQTT topic filter, with + and # wildcards and the
+	// {device} and {tenant} placeholders
+	Topic string `json:"topic" yaml:"topic"`
+	// Device matches the device ID of the client's certificate
+	Device string `json:"device,omitempty" yaml:"device"`
+	// Tenant matches the tenant of the client's certificate
+	Tenant  string `json:"tenant,omitempty" yaml:"tenant"`
+	Comment string `json:"comment,omitempty" yaml:"comment"`
+	// Static rules come from the configuration and cannot be removed at
+	// runtime
+	Static    bool      `json:"static,omitempty" yaml:"-"`
+	CreatedAt time.Time `json:"created_at" yaml:"-"`
+}
+
+// Client is what the rules know about an MQTT client
+type Client struct {
+	// ID is the MQTT client identifier
+	ID   string
+	Addr string
+	// Device and Tenant come from the client's device certificate, empty
+	// for clients without one
+	Device string
+	Tenant string
+}
+
+// Options configures an ACL
+type Options struct {
+	// Rules are the rules of the configuration
+	Rules []Rule
+	// Path persists the rules added at runtime; empty keeps them in memory
+	Path string
+	// Audit receives the denials and rule changes; nil uses the global
+	// audit logger
+	Audit *audit.AuditLogger
+	// Now returns the current time; nil uses time.Now
+	Now func() time.Time
+}
+
+// ACL holds the topic rules of a broker. Deny rules win over allow rules;
+// once there is an allow rule for publishing or subscribing, only topics
+// one matches may be published to or subscribed to.
+type ACL struct {
+	opts Options
+
+	mu    sync.RWMutex
+	rules []Rule
+}
+
+// New returns an ACL with the configured rules and the runtime rules
+// persisted at opts.Path
+func New(opts Options) (*ACL, error) {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	a := &ACL{opts: opts}
+	for i, rule := range opts.Rules {
+		rule.ID = "config-" + strconv.Itoa(i+1)
+		rule.Static = true
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+		a.rules = append(a.rules, rule)
+	}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// LoadRules reads rules from a YAML or JSON file, which holds either
+// {"rules": [...]} or a bare list of rules
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	unmarshal := yaml.Unmarshal
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		unmarshal = json.Unmarshal
+	}
+	var file struct {
+		Rules []Rule `json:"rules" yaml:"rules"`
+	}
+	if err := unmarshal(data, &file); err != nil {
+		if err := unmarshal(data, &file.Rules); err != nil {
+			return nil, fmt.Errorf("topicacl: invalid rules %s: %w", path, err)
+		}
+	}
+	for i := range file.Rules {
+		if err := file.Rules[i].validate(); err != nil {
+			return nil, fmt.Errorf("topicacl: rule %d in %s: %w", i+1, path, err)
+		}
+	}
+	return file.Rules, nil
+}
+
+// Rules returns the rules, those of the configuration first
+func (a *ACL) Rules() []Rule {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return slices.Clone(a.rules)
+}
+
+// AddRule adds a rule at runtime. It applies to the next messages and
+// subscriptions; existing subscriptions stay until clients reconnect.
+func (a *ACL) AddRule(ctx context.Context, rule Rule, actor string) (Rule, error) {
+	if err := rule.validate(); err != nil {
+		return Rule{}, err
+	}
+	rule.ID = crypto.GenerateID()[:16]
+	rule.Static = false
+	rule.CreatedAt = a.opts.Now().UTC()
+
+	a.mu.Lock()
+	a.rules = append(a.rules, rule)
+	err := a.saveLocked()
+	if err != nil {
+		a.rules = a.rules[:len(a.rules)-1]
+	}
+	a.mu.Unlock()
+	if err != nil {
+		return Rule{}, err
+	}
+
+	a.auditChange(ctx, "add_rule", rule, actor)
+	return rule, nil
+}
+
+// RemoveRule removes a rule added at runtime
+func (a *ACL) RemoveRule(ctx context.Context, id, actor string) error {
+	a.mu.Lock()
+	i := slices.IndexFunc(a.rules, func(r Rule) bool { return r.ID == id })
+	if i < 0 {
+		a.mu.Unlock()
+		return fmt.Errorf("%w: rule %s", ErrNotFound, id)
+	}
+	rule := a.rules[i]
+	if rule.Static {
+		a.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrReadOnly, id)
+	}
+	a.rules = slices.Delete(a.rules, i, i+1)
+	err := a.saveLocked()
+	if err != nil {
+		a.rules = slices.Insert(a.rules, i, rule)
+	}
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	a.auditChange(ctx, "remove_rule", rule, actor)
+	return nil
+}
+
+// Check returns an error wrapping ErrDenied when the rules keep c from
+// publishing to a topic or subscribing to a topic filter. A subscription
+// must fall within an allow rule, and is denied when it overlaps a deny
+// rule, so # cannot read around the topics denied.
+func (a *ACL) Check(c Client, access Access, topic string) error {
+	if access == Publish && (strings.ContainsAny(topic, "+#") || !ValidFilter(topic)) {
+		return fmt.Errorf("%w: invalid topic %q", ErrDenied, topic)
+	}
+	if access == Subscribe && !ValidFilter(topic) {
+		return fmt.Errorf("%w: invalid topic filter %q", ErrDenied, topic)
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	hasAllow, allowed := false, false
+	for _, rule := range a.rules {
+		if rule.Access != "" && rule.Access != access {
+			continue
+		}
+		if rule.Action == Allow {
+			hasAllow = true
+		}
+		filter, ok := rule.filter(c)
+		if !ok {
+			continue
+		}
+		if rule.Action == Deny {
+			if overlaps(filter, topic) {
+				return fmt.Errorf("%w by rule %s", ErrDenied, rule.ID)
+			}
+			continue
+		}
+		if covers(filter, topic) {
+			allowed = true
+		}
+	}
+	if hasAllow && !allowed {
+		return fmt.Errorf("%w: no allow rule matches", ErrDenied)
+	}
+	return nil
+}
+
+// Authorize checks the rules like Check, recording denials in the audit
+// log
+func (a *ACL) Authorize(c Client, access Access, topic string) error {
+	err := a.Check(c, access, topic)
+	if err != nil {
+		a.auditDenied(c, access, topic, err)
+	}
+	return err
+}
+
+// ValidFilter reports whether filter is a valid MQTT topic filter: # may
+// only be the last level, and wildcards fill whole levels
+func ValidFilter(filter string) bool {
+	if filter == "" || len(filter) > 65535 || strings.ContainsRune(filter, 0) {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if len(level) > 1 && strings.ContainsAny(level, "+#") {
+			return false
+		}
+		if level == "#" && i != len(levels)-1 {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks the rule's action, access and topic
+func (r *Rule) validate() error {
+	switch r.Action {
+	case Allow, Deny:
+	default:
+		return fmt.Errorf("%w: action must be allow or deny, not %q", ErrInvalid, r.Action)
+	}
+	switch r.Access {
+	case "", Publish, Subscribe:
+	default:
+		return fmt.Errorf("%w: access must be %s or %s, not %q", ErrInvalid, Publish, Subscribe, r.Access)
+	}
+	example := strings.NewReplacer(DevicePlaceholder, "device", TenantPlaceholder, "tenant").Replace(r.Topic)
+	if !ValidFilter(example) {
+		return fmt.Errorf("%w: invalid topic filter %q", ErrInvalid, r.Topic)
+	}
+	return nil
+}
+
+// filter returns the rule's topic filter for c, reporting false when the
+// rule does not apply to c
+func (r *Rule) filter(c Client) (string, bool) {
+	if r.Device != "" && r.Device != c.Device {
+		return "", false
+	}
+	if r.Tenant != "" && r.Tenant != c.Tenant {
+		return "", false
+	}
+	filter := r.Topic
+	if strings.Contains(filter, DevicePlaceholder) {
+		if !literalLevel(c.Device) {
+			return "", false
+		}
+		filter = strings.ReplaceAll(filter, DevicePlaceholder, c.Device)
+	}
+	if strings.Contains(filter, TenantPlaceholder) {
+		if !literalLevel(c.Tenant) {
+			return "", false
+		}
+		filter = strings.ReplaceAll(filter, TenantPlaceholder, c.Tenant)
+	}
+	return filter, true
+}
+
+// literalLevel reports whether s can stand in a topic level without
+// widening it
+func literalLevel(s string) bool {
+	return s != "" && !strings.ContainsAny(s, "/+#\x00")
+}
+
+// covers reports whether every topic matching sub also matches filter; for
+// a topic without wildcards, whether it matches filter
+func covers(filter, sub string) bool {
+	f, s := strings.Split(filter, "/"), strings.Split(sub, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i == len(s) {
+			return false
+		}
+		if level == "+" {
+			if s[i] == "#" {
+				return false
+			}
+			continue
+		}
+		if level != s[i] {
+			return false
+		}
+	}
+	return len(s) == len(f)
+}
+
+// overlaps reports whether some topic matches both filters
+func overlaps(a, b string) bool {
+	x, y := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; ; i++ {
+		if i == len(x) || i == len(y) {
+			// A trailing # also matches its parent level
+			return len(x) == len(y) || (i < len(x) && x[i] == "#") || (i < len(y) && y[i] == "#")
+		}
+		if x[i] == "#" || y[i] == "#" {
+			return true
+		}
+		if x[i] != "+" && y[i] != "+" && x[i] != y[i] {
+			return false
+		}
+	}
+}
+
+func (a *ACL) load() error {
+	if a.opts.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(a.opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("topicacl: corrupt rule store %s: %w", a.opts.Path, err)
+	}
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("topicacl: rule %s in %s: %w", rule.ID, a.opts.Path, err)
+		}
+		rule.Static = false
+		a.rules = append(a.rules, rule)
+	}
+	return nil
+}
+
+func (a *ACL) saveLocked() error {
+	if a.opts.Path == "" {
+		return nil
+	}
+	rules := []Rule{}
+	for _, rule := range a.rules {
+		if !rule.Static {
+			rules = append(rules, rule)
+		}
+	}
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(a.opts.Path), 0700); err != nil {
+		return err
+	}
+	tmp := a.opts.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.opts.Path)
+}
+
+func (a *ACL) auditLogger() *audit.AuditLogger {
+	if a.opts.Audit != nil {
+		return a.opts.Audit
+	}
+	return audit.GlobalAuditLogger
+}
+
+// auditDenied records a denied publish or subscription
+func (a *ACL) auditDenied(c Client, access Access, topic string, reason error) {
+	logger := a.auditLogger()
+	if logger == nil {
+		slog.Warn("MQTT topic denied", "client", c.ID, "device", c.Device, "access", access, "topic", topic, "reason", reason)
+		return
+	}
+	host, _, err := net.SplitHostPort(c.Addr)
+	if err != nil {
+		host = c.Addr
+	}
+	details := map[string]interface{}{"client_id": c.ID, "topic": topic}
+	if c.Device != "" {
+		details["device"] = c.Device
+	}
+	if c.Tenant != "" {
+		details["tenant"] = c.Tenant
+	}
+	event := &audit.AuditEvent{
+		Type:      audit.AuditEventTypeSecurity,
+		Level:     audit.AuditLevelWarning,
+		IPAddress: host,
+		Resource:  topic,
+		Action:    string(access),
+		Result:    "denied",
+		Message:   fmt.Sprintf("MQTT %s to %s by client %s denied: %v", access, topic, c.ID, reason),
+		Details:   details,
+		Source:    "mqtt-acl",
+		Category:  "mqtt",
+		Tags:      []string{"mqtt-acl", "denied"},
+	}
+	if err := logger.LogEvent(context.Background(), event); err != nil {
+		slog.Warn("topicacl: failed to write audit event", "error", err)
+	}
+}
+
+// auditChange records a rule change
+func (a *ACL) auditChange(ctx context.Context, action string, rule Rule, actor string) {
+	logger := a.auditLogger()
+	if logger == nil {
+		slog.Info("MQTT topic ACL changed", "action", action, "rule", rule.ID, "actor", actor)
+		return
+	}
+	details := map[string]interface{}{"rule": rule.ID, "rule_action": string(rule.Action), "topic": rule.Topic}
+	if rule.Access != "" {
+		details["access"] = string(rule.Access)
+	}
+	if rule.Device != "" {
+		details["device"] = rule.Device
+	}
+	if rule.Tenant != "" {
+		details["tenant"] = rule.Tenant
+	}
+	if err := logger.LogAdminEvent(ctx, actor, action, "success", details); err != nil {
+		slog.Warn("topicacl: failed to write audit event", "error", err)
+	}
+}
@@ -0,0 +1,150 @@
+package topicacl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	acl, err := New(Options{Rules: []Rule{
+		{Action: Allow, Topic: "devices/{device}/#"},
+		{Action: Allow, Access: Subscribe, Topic: "tenants/{tenant}/broadcast/#"},
+		{Action: Deny, Topic: "devices/+/secrets/#"},
+	}})
+	require.NoError(t, err)
+
+	sensor := Client{ID: "c1", Device: "sensor-1", Tenant: "plant-a"}
+	other := Client{ID: "c2", Device: "sensor-2", Tenant: "plant-b"}
+	anonymous := Client{ID: "c3"}
+
+	// Devices are confined to their own topics
+	assert.NoError(t, acl.Check(sensor, Publish, "devices/sensor-1/temperature"))
+	assert.NoError(t, acl.Check(sensor, Subscribe, "devices/sensor-1/commands/+"))
+	assert.ErrorIs(t, acl.Check(sensor, Publish, "devices/sensor-2/temperature"), ErrDenied)
+	assert.ErrorIs(t, acl.Check(sensor, Subscribe, "devices/+/temperature"), ErrDenied)
+	assert.ErrorIs(t, acl.Check(sensor, Subscribe, "#"), ErrDenied)
+
+	// Tenants share their broadcast topics, for reading only
+	assert.NoError(t, acl.Check(sensor, Subscribe, "tenants/plant-a/broadcast/firmware"))
+	assert.ErrorIs(t, acl.Check(sensor, Publish, "tenants/plant-a/broadcast/firmware"), ErrDenied)
+	assert.ErrorIs(t, acl.Check(other, Subscribe, "tenants/plant-a/broadcast/#"), ErrDenied)
+
+	// Deny rules win, also over subscriptions that would read around them
+	assert.ErrorIs(t, acl.Check(sensor, Publish, "devices/sensor-1/secrets/key"), ErrDenied)
+	assert.ErrorIs(t, acl.Check(sensor, Subscribe, "devices/sensor-1/#"), ErrDenied)
+
+	// Rules naming the device do not apply to clients without one
+	assert.ErrorIs(t, acl.Check(anonymous, Publish, "devices/sensor-1/temperature"), ErrDenied)
+
+	// Topics must be valid
+	assert.ErrorIs(t, acl.Check(sensor, Publish, "devices/sensor-1/+"), ErrDenied)
+	assert.ErrorIs(t, acl.Check(sensor, Subscribe, "devices/sensor-1/#/x"), ErrDenied)
+
+	ctx := context.Background()
+	rule, err := acl.AddRule(ctx, Rule{Action: Allow, Device: "sensor-2", Topic: "devices/+/temperature", Access: Subscribe}, "admin")
+	require.NoError(t, err)
+	assert.NoError(t, acl.Check(other, Subscribe, "devices/+/temperature"))
+	assert.ErrorIs(t, acl.Check(sensor, Subscribe, "devices/+/temperature"), ErrDenied)
+
+	require.NoError(t, acl.RemoveRule(ctx, rule.ID, "admin"))
+	assert.ErrorIs(t, acl.RemoveRule(ctx, rule.ID, "admin"), ErrNotFound)
+	assert.ErrorIs(t, acl.RemoveRule(ctx, "config-1", "admin"), ErrReadOnly)
+}
+
+func TestCheckWithoutAllowRules(t *testing.T) {
+	acl, err := New(Options{Rules: []Rule{{Action: Deny, Access: Publish, Topic: "$SYS/#"}}})
+	require.NoError(t, err)
+
+	// Without allow rules everything not denied is allowed
+	client := Client{ID: "c1"}
+	assert.NoError(t, acl.Check(client, Publish, "a/b"))
+	assert.NoError(t, acl.Check(client, Subscribe, "#"))
+	assert.ErrorIs(t, acl.Check(client, Publish, "$SYS/broker/uptime"), ErrDenied)
+}
+
+func TestFilters(t *testing.T) {
+	for _, c := range []struct {
+		filter, sub string
+		covers      bool
+		overlaps    bool
+	}{
+		{"a/b", "a/b", true, true},
+		{"a/b", "a/c", false, false},
+		{"a/+", "a/b", true, true},
+		{"a/+", "a/+", true, true},
+		{"a/+", "a/#", false, true},
+		{"a/#", "a", true, true},
+		{"a/#", "a/b/c", true, true},
+		{"a/b", "a/#", false, true},
+		{"a/b", "+/+", false, true},
+		{"a/+/c", "a/b", false, false},
+		{"#", "x/y", true, true},
+		{"a/b/c", "a/b", false, false},
+	} {
+		assert.Equal(t, c.covers, covers(c.filter, c.sub), "covers(%q, %q)", c.filter, c.sub)
+		assert.Equal(t, c.overlaps, overlaps(c.filter, c.sub), "overlaps(%q, %q)", c.filter, c.sub)
+		assert.Equal(t, c.overlaps, overlaps(c.sub, c.filter), "overlaps(%q, %q)", c.sub, c.filter)
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	for _, rule := range []Rule{
+		{Action: "maybe", Topic: "a"},
+		{Action: Allow},
+		{Action: Allow, Topic: "a/#/b"},
+		{Action: Allow, Topic: "a/b+"},
+		{Action: Deny, Topic: "a", Access: "read"},
+	} {
+		_, err := New(Options{Rules: []Rule{rule}})
+		assert.ErrorIs(t, err, ErrInvalid, "%+v", rule)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mqtt-acl.json")
+	static := []Rule{{Action: Deny, Topic: "private/#"}}
+	acl, err := New(Options{Rules: static, Path: path})
+	require.NoError(t, err)
+	rule, err := acl.AddRule(context.Background(), Rule{Action: Allow, Topic: "devices/{device}/#", Comment: "own topics"}, "admin")
+	require.NoError(t, err)
+
+	reloaded, err := New(Options{Rules: static, Path: path})
+	require.NoError(t, err)
+	rules := reloaded.Rules()
+	require.Len(t, rules, 2)
+	assert.True(t, rules[0].Static)
+	assert.Equal(t, rule.ID, rules[1].ID)
+	assert.Equal(t, "own topics", rules[1].Comment)
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "mqtt-acl.yaml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte(`rules:
+  - action: allow
+    topic: devices/{device}/#
+  - action: deny
+    access: subscribe
+    tenant: plant-b
+    topic: "#"
+`), 0600))
+	rules, err := LoadRules(yamlPath)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, Rule{Action: Deny, Access: Subscribe, Tenant: "plant-b", Topic: "#"}, rules[1])
+
+	jsonPath := filepath.Join(dir, "mqtt-acl.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`[{"action": "allow", "topic": "a/#"}]`), 0600))
+	rules, err = LoadRules(jsonPath)
+	require.NoError(t, err)
+	assert.Equal(t, []Rule{{Action: Allow, Topic: "a/#"}}, rules)
+
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`[{"action": "allow", "topic": "a/#/b"}]`), 0600))
+	_, err = LoadRules(jsonPath)
+	assert.ErrorIs(t, err, ErrInvalid)
+}
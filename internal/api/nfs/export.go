@@ -0,0 +1,275 @@
+package nfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/directory"
+)
+
+// File handles are a version byte, the ID of the export and a hash of the
+// key, so they stay valid across restarts and while a key exists
+const (
+	handleVersion = 1
+	handleSize    = 1 + 8 + 16
+)
+
+// Bits of ACCESS3
+const (
+	accessRead    = 0x01
+	accessLookup  = 0x02
+	accessModify  = 0x04
+	accessExtend  = 0x08
+	accessDelete  = 0x10
+	accessExecute = 0x20
+)
+
+// export is an export and the listing of its namespace
+type export struct {
+	Export
+	id      [8]byte
+	clients []*net.IPNet
+
+	mu      sync.Mutex
+	listing *listing
+}
+
+// allows reports whether a client may use the export
+func (x *export) allows(ip net.IP) bool {
+	if len(x.clients) == 0 {
+		return true
+	}
+	return ip != nil && slices.ContainsFunc(x.clients, func(n *net.IPNet) bool { return n.Contains(ip) })
+}
+
+// user maps the credentials of a call to the user it acts as
+func (x *export) user(cred credentials) credentials {
+	if cred.anonymous || x.Squash == SquashAll || (x.Squash == SquashRoot && cred.uid == 0) {
+		return credentials{uid: x.AnonUID, gid: x.AnonGID}
+	}
+	return cred
+}
+
+// access returns the ACCESS3 bits user has on e
+func (x *export) access(user credentials, e *entry) uint32 {
+	mode := x.FileMode
+	if e.dir {
+		mode = x.DirMode
+	}
+	var bits uint32
+	switch {
+	case user.uid == 0:
+		bits = 7
+	case user.uid == x.UID:
+		bits = mode >> 6 & 7
+	case user.gid == x.GID || slices.Contains(user.gids, x.GID):
+		bits = mode >> 3 & 7
+	default:
+		bits = mode & 7
+	}
+
+	var granted uint32
+	if bits&4 != 0 {
+		granted |= accessRead
+	}
+	if bits&2 != 0 && !x.ReadOnly {
+		granted |= accessModify | accessExtend | accessDelete
+	}
+	if bits&1 != 0 {
+		if e.dir {
+			granted |= accessLookup
+		} else {
+			granted |= accessExecute
+		}
+	}
+	return granted
+}
+
+// handle returns the file handle of e
+func (x *export) handle(e *entry) []byte {
+	fh := make([]byte, 0, handleSize)
+	fh = append(fh, handleVersion)
+	fh = append(fh, x.id[:]...)
+	return append(fh, e.hash[:]...)
+}
+
+// fsid identifies the export to clients
+func (x *export) fsid() uint64 { return binary.BigEndian.Uint64(x.id[:]) }
+
+// entry is a file or directory of an export
+type entry struct {
+	// key is the key of the file, or the folder ending in "/"; the root
+	// is the namespace
+	key   string
+	dir   bool
+	size  int64
+	mtime time.Time
+	hash  [16]byte
+}
+
+func (e *entry) name() string { return directory.Base(e.key) }
+
+func (e *entry) fileid() uint64 { return binary.BigEndian.Uint64(e.hash[:8]) }
+
+// listing is the tree of the keys of a namespace at one time
+type listing struct {
+	at      time.Time
+	root    *entry
+	entries map[string]*entry
+	handles map[[16]byte]*entry
+	// children are sorted by key
+	children map[string][]*entry
+	files    uint64
+	bytes    uint64
+}
+
+func newListing(namespace string, objects []directory.Object) *listing {
+	l := &listing{
+		at:       time.Now(),
+		entries:  make(map[string]*entry),
+		handles:  make(map[[16]byte]*entry),
+		children: make(map[string][]*entry),
+	}
+	l.root = l.add(&entry{key: namespace, dir: true})
+	for _, o := range objects {
+		if !strings.HasPrefix(o.Key, namespace) {
+			continue
+		}
+		e := l.entries[o.Key]
+		if e == nil {
+			if o.IsDir() {
+				e = l.dir(o.Key)
+			} else {
+				e = l.add(&entry{key: o.Key, size: o.Size})
+				l.files++
+				l.bytes += uint64(max(o.Size, 0))
+			}
+		}
+		// Directories change when anything below them does
+		for ; ; e = l.parent(e) {
+			if o.ModTime.After(e.mtime) {
+				e.mtime = o.ModTime
+			}
+			if e == l.root {
+				break
+			}
+		}
+	}
+	for _, children := range l.children {
+		sort.Slice(children, func(i, j int) bool { return children[i].key < children[j].key })
+	}
+	return l
+}
+
+// add adds e, and the directories above it
+func (l *listing) add(e *entry) *entry {
+	e.hash = keyHash(e.key)
+	l.entries[e.key] = e
+	l.handles[e.hash] = e
+	if l.root != nil {
+		parent := l.dir(directory.Parent(e.key))
+		l.children[parent.key] = append(l.children[parent.key], e)
+	}
+	return e
+}
+
+// dir returns the directory of key, adding it when missing
+func (l *listing) dir(key string) *entry {
+	if e := l.entries[key]; e != nil {
+		return e
+	}
+	return l.add(&entry{key: key, dir: true})
+}
+
+// parent returns the directory holding e; the root is its own parent
+func (l *listing) parent(e *entry) *entry {
+	if e == l.root {
+		return e
+	}
+	return l.entries[directory.Parent(e.key)]
+}
+
+// lookup finds name in dir
+func (l *listing) lookup(dir *entry, name string) *entry {
+	switch name {
+	case ".":
+		return dir
+	case "..":
+		return l.parent(dir)
+	}
+	if e := l.entries[dir.key+name+directory.Separator]; e != nil {
+		return e
+	}
+	return l.entries[dir.key+name]
+}
+
+func keyHash(key string) [16]byte {
+	sum := sha256.Sum256([]byte(key))
+	return [16]byte(sum[:16])
+}
+
+// list returns the listing of an export. A listing is reused for
+// AttrCacheTTL unless fresh is set.
+func (s *Server) list(ctx context.Context, x *export, fresh bool) (*listing, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if !fresh && x.listing != nil && time.Since(x.listing.at) < s.config.AttrCacheTTL {
+		return x.listing, nil
+	}
+	objects, err := s.store.List(ctx, x.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	x.listing = newListing(x.Namespace, objects)
+	return x.listing, nil
+}
+
+// stat returns the entry of key; changes through the server drop the
+// listing, so it is current
+func (s *Server) stat(ctx context.Context, x *export, key string) *entry {
+	l, err := s.list(ctx, x, false)
+	if err != nil {
+		return nil
+	}
+	return l.entries[key]
+}
+
+// resolve returns the export, listing and entry of a file handle, or the
+// status to fail with
+func (c *conn) resolve(ctx context.Context, fh []byte) (*export, *listing, *entry, uint32) {
+	if len(fh) != handleSize || fh[0] != handleVersion {
+		return nil, nil, nil, nfs3ErrBadHandle
+	}
+	i := slices.IndexFunc(c.server.exports, func(x *export) bool { return bytes.Equal(x.id[:], fh[1:9]) })
+	if i < 0 {
+		return nil, nil, nil, nfs3ErrStale
+	}
+	x := c.server.exports[i]
+	if !x.allows(c.ip) {
+		return nil, nil, nil, nfs3ErrAcces
+	}
+	hash := [16]byte(fh[9:])
+	l, err := c.server.list(ctx, x, false)
+	if err != nil {
+		return nil, nil, nil, nfs3ErrIO
+	}
+	e := l.handles[hash]
+	if e == nil {
+		// The key may be newer than the listing
+		if l, err = c.server.list(ctx, x, true); err != nil {
+			return nil, nil, nil, nfs3ErrIO
+		}
+		if e = l.handles[hash]; e == nil {
+			return nil, nil, nil, nfs3ErrStale
+		}
+	}
+	return x, l, e, nfs3OK
+}
@@ -0,0 +1,123 @@
+package nfs
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/directory"
+)
+
+// MOUNT v3 (RFC 1813, appendix I) hands out the root handles of exports
+
+const (
+	mountProgram = 100005
+	mountVersion = 3
+
+	mountProcNull    = 0
+	mountProcMnt     = 1
+	mountProcDump    = 2
+	mountProcUmnt    = 3
+	mountProcUmntAll = 4
+	mountProcExport  = 5
+
+	mnt3OK        = 0
+	mnt3ErrNoEnt  = 2
+	mnt3ErrAcces  = 13
+	mnt3ErrNotDir = 20
+
+	// maxPath is the longest mount path
+	maxPath = 1024
+)
+
+var mountProcedures = map[uint32]procedure{
+	mountProcNull:    (*conn).null,
+	mountProcMnt:     (*conn).mnt,
+	mountProcDump:    (*conn).dump,
+	mountProcUmnt:    (*conn).umnt,
+	mountProcUmntAll: (*conn).null,
+	mountProcExport:  (*conn).exportList,
+}
+
+func (c *conn) null(context.Context, *call, *writer) error { return nil }
+
+// mnt returns the handle of an export, or of a directory in it
+func (c *conn) mnt(ctx context.Context, req *call, w *writer) error {
+	dirpath := req.args.string(maxPath)
+	if req.args.err != nil {
+		return errGarbage
+	}
+
+	// The longest export path holding dirpath
+	var x *export
+	for _, e := range c.server.exports {
+		if (dirpath == e.Path || strings.HasPrefix(dirpath, strings.TrimSuffix(e.Path, "/")+"/")) &&
+			(x == nil || len(e.Path) > len(x.Path)) {
+			x = e
+		}
+	}
+	if x == nil {
+		w.uint32(mnt3ErrNoEnt)
+		return nil
+	}
+	if !x.allows(c.ip) {
+		c.server.logger.Warn("NFS client not allowed to mount", "export", x.Path, "remote", c.RemoteAddr())
+		w.uint32(mnt3ErrAcces)
+		return nil
+	}
+	l, err := c.server.list(ctx, x, false)
+	if err != nil {
+		return err
+	}
+	e := l.root
+	if rest := strings.TrimPrefix(dirpath, x.Path); strings.Trim(rest, "/") != "" {
+		key, err := directory.CleanKey(x.Namespace + strings.Trim(rest, "/"))
+		if err != nil {
+			w.uint32(mnt3ErrNoEnt)
+			return nil
+		}
+		if e = l.entries[key+directory.Separator]; e == nil {
+			if l.entries[key] != nil {
+				w.uint32(mnt3ErrNotDir)
+			} else {
+				w.uint32(mnt3ErrNoEnt)
+			}
+			return nil
+		}
+	}
+
+	c.server.logger.Debug("NFS client mounted an export", "path", dirpath, "remote", c.RemoteAddr())
+	w.uint32(mnt3OK)
+	w.opaque(x.handle(e))
+	w.uint32(1) // auth flavors
+	w.uint32(authSys)
+	return nil
+}
+
+// dump lists the mounts of clients, which are not recorded
+func (c *conn) dump(_ context.Context, _ *call, w *writer) error {
+	w.bool(false)
+	return nil
+}
+
+func (c *conn) umnt(_ context.Context, req *call, _ *writer) error {
+	req.args.string(maxPath)
+	if req.args.err != nil {
+		return errGarbage
+	}
+	return nil
+}
+
+// exportList lists the exports and the clients allowed to mount them
+func (c *conn) exportList(_ context.Context, _ *call, w *writer) error {
+	for _, x := range c.server.exports {
+		w.bool(true)
+		w.string(x.Path)
+		for _, client := range x.Clients {
+			w.bool(true)
+			w.string(client)
+		}
+		w.bool(false)
+	}
+	w.bool(false)
+	return nil
+}
@@ -0,0 +1,975 @@
+package nfs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"github.com/Skpow1234/Peervault/internal/retention"
+)
+
+// NFS v3 (RFC 1813)
+
+const (
+	nfsProgram = 100003
+	nfsVersion = 3
+
+	nfs3OK             = 0
+	nfs3ErrNoEnt       = 2
+	nfs3ErrIO          = 5
+	nfs3ErrAcces       = 13
+	nfs3ErrExist       = 17
+	nfs3ErrXDev        = 18
+	nfs3ErrNotDir      = 20
+	nfs3ErrIsDir       = 21
+	nfs3ErrInval       = 22
+	nfs3ErrFBig        = 27
+	nfs3ErrROFS        = 30
+	nfs3ErrNameTooLong = 63
+	nfs3ErrNotEmpty    = 66
+	nfs3ErrStale       = 70
+	nfs3ErrBadHandle   = 10001
+	nfs3ErrNotSync     = 10002
+	nfs3ErrNotSupp     = 10004
+	nfs3ErrTooSmall    = 10005
+
+	typeRegular   = 1
+	typeDirectory = 2
+
+	unstable = 0
+	fileSync = 2
+
+	createUnchecked = 0
+	createGuarded   = 1
+	createExclusive = 2
+
+	setToClientTime = 2
+
+	// maxHandle and maxName are the longest file handle and file name
+	maxHandle = 64
+	maxName   = 255
+
+	// Sizes of encoded results, for the limits of directory listings
+	attrSize        = 84
+	postOpAttrSize  = 4 + attrSize
+	postOpFileSize  = 4 + 4 + handleSize + 3
+	dirListOverhead = 4 + postOpAttrSize + 8 + 4 + 4
+
+	// dirSize is the size reported for directories
+	dirSize = 4096
+	// freeBytes and freeFiles are reported as free, the store having no
+	// fixed capacity
+	freeBytes = 1 << 50
+	freeFiles = 1 << 32
+)
+
+var nfsProcedures = map[uint32]procedure{
+	0:  (*conn).null,
+	1:  (*conn).getattr,
+	2:  (*conn).setattr,
+	3:  (*conn).lookup,
+	4:  (*conn).checkAccess,
+	5:  (*conn).readlink,
+	6:  (*conn).readFile,
+	7:  (*conn).writeFile,
+	8:  (*conn).create,
+	9:  (*conn).mkdir,
+	10: (*conn).notSupported,
+	11: (*conn).notSupported,
+	12: (*conn).remove,
+	13: (*conn).rmdir,
+	14: (*conn).rename,
+	15: (*conn).link,
+	16: (*conn).readdir,
+	17: (*conn).readdirplus,
+	18: (*conn).fsstat,
+	19: (*conn).fsinfo,
+	20: (*conn).pathconf,
+	21: (*conn).commit,
+}
+
+var programs = map[uint32]program{
+	mountProgram: {version: mountVersion, procedures: mountProcedures},
+	nfsProgram:   {version: nfsVersion, procedures: nfsProcedures},
+}
+
+// status returns the status a store error fails a call with
+func status(err error) uint32 {
+	switch {
+	case errors.Is(err, directory.ErrNotFound):
+		return nfs3ErrNoEnt
+	case errors.Is(err, directory.ErrExists):
+		return nfs3ErrExist
+	case errors.Is(err, directory.ErrNotEmpty):
+		return nfs3ErrNotEmpty
+	case errors.Is(err, directory.ErrInvalidPath):
+		return nfs3ErrInval
+	case errors.Is(err, retention.ErrLocked):
+		return nfs3ErrAcces
+	default:
+		return nfs3ErrIO
+	}
+}
+
+// attributes writes the fattr3 of e
+func (s *Server) attributes(w *writer, x *export, e *entry) {
+	size, mtime := s.overlay(e)
+	if e.dir {
+		w.uint32(typeDirectory)
+		w.uint32(x.DirMode)
+		w.uint32(2)
+		size = dirSize
+	} else {
+		w.uint32(typeRegular)
+		w.uint32(x.FileMode)
+		w.uint32(1)
+	}
+	w.uint32(x.UID)
+	w.uint32(x.GID)
+	w.uint64(uint64(size))
+	w.uint64(uint64(size))
+	w.uint64(0) // rdev
+	w.uint64(x.fsid())
+	w.uint64(e.fileid())
+	for range 3 {
+		writeTime(w, mtime)
+	}
+}
+
+func writeTime(w *writer, t time.Time) {
+	if t.Unix() < 0 {
+		t = time.Unix(0, 0)
+	}
+	w.uint32(uint32(t.Unix()))
+	w.uint32(uint32(t.Nanosecond()))
+}
+
+// postOp writes the post_op_attr of e, which may be nil
+func (s *Server) postOp(w *writer, x *export, e *entry) {
+	w.bool(e != nil)
+	if e != nil {
+		s.attributes(w, x, e)
+	}
+}
+
+// wcc writes the wcc_data of e, with its attributes after the change only
+func (s *Server) wcc(w *writer, x *export, e *entry) {
+	w.bool(false)
+	s.postOp(w, x, e)
+}
+
+// current returns the entry of e after a change
+func (c *conn) current(ctx context.Context, x *export, e *entry) *entry {
+	if now := c.server.stat(ctx, x, e.key); now != nil {
+		return now
+	}
+	return e
+}
+
+// dirop is the directory and name a call operates on
+type dirop struct {
+	x    *export
+	l    *listing
+	dir  *entry
+	name string
+}
+
+// readDirop reads diropargs3. A status other than nfs3OK fails the call.
+func (c *conn) readDirop(ctx context.Context, r *reader) (dirop, uint32, error) {
+	fh := r.opaque(maxHandle)
+	name := r.string(maxPath)
+	if r.err != nil {
+		return dirop{}, 0, errGarbage
+	}
+	x, l, dir, st := c.resolve(ctx, fh)
+	op := dirop{x: x, l: l, dir: dir, name: name}
+	switch {
+	case st != nfs3OK:
+		return op, st, nil
+	case !dir.dir:
+		return op, nfs3ErrNotDir, nil
+	case len(name) > maxName:
+		return op, nfs3ErrNameTooLong, nil
+	}
+	return op, nfs3OK, nil
+}
+
+// change checks that user may change the entries of op.dir and that name
+// can be a new entry
+func (op dirop) change(user credentials) uint32 {
+	switch {
+	case op.x.ReadOnly:
+		return nfs3ErrROFS
+	case op.x.access(user, op.dir)&(accessModify|accessLookup) != accessModify|accessLookup:
+		return nfs3ErrAcces
+	case op.name == "" || op.name == "." || op.name == ".." || strings.Contains(op.name, directory.Separator):
+		return nfs3ErrInval
+	}
+	return nfs3OK
+}
+
+// dirWcc writes the wcc_data of op.dir, or of nothing when it was not
+// resolved
+func (c *conn) dirWcc(ctx context.Context, w *writer, op dirop) {
+	if op.dir == nil {
+		c.server.wcc(w, nil, nil)
+		return
+	}
+	c.server.wcc(w, op.x, c.server.stat(ctx, op.x, op.dir.key))
+}
+
+func (c *conn) getattr(ctx context.Context, req *call, w *writer) error {
+	fh := req.args.opaque(maxHandle)
+	if req.args.err != nil {
+		return errGarbage
+	}
+	x, _, e, st := c.resolve(ctx, fh)
+	w.uint32(st)
+	if st == nfs3OK {
+		c.server.attributes(w, x, e)
+	}
+	return nil
+}
+
+// setattr changes the size of files. Modes, owners and times are those of
+// the export and changes to them are ignored.
+func (c *conn) setattr(ctx context.Context, req *call, w *writer) error {
+	r := req.args
+	fh := r.opaque(maxHandle)
+	var changes, setSize bool
+	var size uint64
+	for range 3 { // mode, uid, gid
+		if r.bool() {
+			r.uint32()
+			changes = true
+		}
+	}
+	if setSize = r.bool(); setSize {
+		size = r.uint64()
+	}
+	for range 2 { // atime, mtime
+		if how := r.uint32(); how != 0 {
+			changes = true
+			if how == setToClientTime {
+				r.uint64()
+			}
+		}
+	}
+	guard := r.bool()
+	var ctime []byte
+	if guard {
+		ctime = r.fixed(8)
+	}
+	if r.err != nil {
+		return errGarbage
+	}
+
+	x, _, e, st := c.resolve(ctx, fh)
+	if st == nfs3OK && guard {
+		current := &writer{}
+		_, mtime := c.server.overlay(e)
+		writeTime(current, mtime)
+		if string(current.buf) != string(ctime) {
+			st = nfs3ErrNotSync
+		}
+	}
+	if st == nfs3OK && (changes || setSize) && x.ReadOnly {
+		st = nfs3ErrROFS
+	}
+	if st == nfs3OK && setSize {
+		st = c.resize(ctx, x, e, req.cred, size)
+	}
+	w.uint32(st)
+	if x == nil {
+		c.server.wcc(w, nil, nil)
+	} else {
+		c.server.wcc(w, x, c.current(ctx, x, e))
+	}
+	return nil
+}
+
+func (c *conn) resize(ctx context.Context, x *export, e *entry, cred credentials, size uint64) uint32 {
+	switch {
+	case e.dir:
+		return nfs3ErrIsDir
+	case x.access(x.user(cred), e)&accessModify == 0:
+		return nfs3ErrAcces
+	case size > uint64(c.server.config.MaxFileSize):
+		return nfs3ErrFBig
+	}
+	if _, err := c.server.modify(ctx, e.key, truncate(int64(size))); err != nil {
+		return status(err)
+	}
+	if err := c.server.flush(ctx, e.key); err != nil {
+		return status(err)
+	}
+	return nfs3OK
+}
+
+func (c *conn) lookup(ctx context.Context, req *call, w *writer) error {
+	op, st, err := c.readDirop(ctx, req.args)
+	if err != nil {
+		return err
+	}
+	var found *entry
+	if st == nfs3OK && op.x.access(op.x.user(req.cred), op.dir)&accessLookup == 0 {
+		st = nfs3ErrAcces
+	}
+	if st == nfs3OK {
+		if found = op.l.lookup(op.dir, op.name); found == nil {
+			st = nfs3ErrNoEnt
+		}
+	}
+	w.uint32(st)
+	if st == nfs3OK {
+		w.opaque(op.x.handle(found))
+		c.server.postOp(w, op.x, found)
+	}
+	if op.dir != nil {
+		c.server.postOp(w, op.x, op.dir)
+	} else {
+		w.bool(false)
+	}
+	return nil
+}
+
+func (c *conn) checkAccess(ctx context.Context, req *call, w *writer) error {
+	fh := req.args.opaque(maxHandle)
+	want := req.args.uint32()
+	if req.args.err != nil {
+		return errGarbage
+	}
+	x, _, e, st := c.resolve(ctx, fh)
+	w.uint32(st)
+	if st != nfs3OK {
+		w.bool(false)
+		return nil
+	}
+	c.server.postOp(w, x, e)
+	w.uint32(want & x.access(x.user(req.cred), e))
+	return nil
+}
+
+// readlink fails, there being no symbolic links
+func (c *conn) readlink(ctx context.Context, req *call, w *writer) error {
+	fh := req.args.opaque(maxHandle)
+	if req.args.err != nil {
+		return errGarbage
+	}
+	x, _, e, st := c.resolve(ctx, fh)
+	if st == nfs3OK {
+		st = nfs3ErrInval
+	}
+	w.uint32(st)
+	if x == nil {
+		w.bool(false)
+	} else {
+		c.server.postOp(w, x, e)
+	}
+	return nil
+}
+
+func (c *conn) readFile(ctx context.Context, req *call, w *writer) error {
+	fh := req.args.opaque(maxHandle)
+	offset, count := req.args.uint64(), req.args.uint32()
+	if req.args.err != nil {
+		return errGarbage
+	}
+	x, _, e, st := c.resolve(ctx, fh)
+	switch {
+	case st != nfs3OK:
+	case e.dir:
+		st = nfs3ErrIsDir
+	case x.access(x.user(req.cred), e)&accessRead == 0:
+		st = nfs3ErrAcces
+	}
+	var data []byte
+	var eof bool
+	if st == nfs3OK {
+		var err error
+		if data, eof, err = c.server.read(ctx, e, offset, min(count, maxIO)); err != nil {
+			st = status(err)
+		}
+	}
+	w.uint32(st)
+	if x == nil {
+		w.bool(false)
+		return nil
+	}
+	c.server.postOp(w, x, e)
+	if st == nfs3OK {
+		w.uint32(uint32(len(data)))
+		w.bool(eof)
+		w.opaque(data)
+	}
+	return nil
+}
+
+func (c *conn) writeFile(ctx context.Context, req *call, w *writer) error {
+	r := req.args
+	fh := r.opaque(maxHandle)
+	offset, count, stable := r.uint64(), r.uint32(), r.uint32()
+	data := r.opaque(maxIO)
+	if r.err != nil || int(count) > len(data) {
+		return errGarbage
+	}
+	data = data[:count]
+
+	x, _, e, st := c.resolve(ctx, fh)
+	switch {
+	case st != nfs3OK:
+	case e.dir:
+		st = nfs3ErrIsDir
+	case x.ReadOnly:
+		st = nfs3ErrROFS
+	case x.access(x.user(req.cred), e)&accessModify == 0:
+		st = nfs3ErrAcces
+	case offset+uint64(count) > uint64(c.server.config.MaxFileSize):
+		st = nfs3ErrFBig
+	}
+	committed := uint32(unstable)
+	if st == nfs3OK {
+		if _, err := c.server.modify(ctx, e.key, writeAt(int64(offset), data)); err != nil {
+			st = status(err)
+		} else if stable != unstable {
+			if err := c.server.flush(ctx, e.key); err != nil {
+				st = status(err)
+			}
+			committed = fileSync
+		}
+	}
+	w.uint32(st)
+	if x == nil {
+		c.server.wcc(w, nil, nil)
+		return nil
+	}
+	c.server.wcc(w, x, c.current(ctx, x, e))
+	if st == nfs3OK {
+		w.uint32(count)
+		w.uint32(committed)
+		w.fixed(c.server.verifier[:])
+	}
+	return nil
+}
+
+// created writes the result of a create of key
+func (c *conn) created(ctx context.Context, w *writer, op dirop, st uint32, key string) {
+	w.uint32(st)
+	if st == nfs3OK {
+		e := c.server.stat(ctx, op.x, key)
+		w.bool(e != nil)
+		if e != nil {
+			w.opaque(op.x.handle(e))
+		}
+		c.server.postOp(w, op.x, e)
+	}
+	c.dirWcc(ctx, w, op)
+}
+
+func (c *conn) create(ctx context.Context, req *call, w *writer) error {
+	op, st, err := c.readDirop(ctx, req.args)
+	if err != nil {
+		return err
+	}
+	r := req.args
+	how := r.uint32()
+	var truncateTo *uint64
+	var verifier [8]byte
+	switch how {
+	case createUnchecked, createGuarded:
+		for range 3 {
+			if r.bool() {
+				r.uint32()
+			}
+		}
+		if r.bool() {
+			size := r.uint64()
+			truncateTo = &size
+		}
+		for range 2 {
+			if r.uint32() == setToClientTime {
+				r.uint64()
+			}
+		}
+	case createExclusive:
+		copy(verifier[:], r.fixed(8))
+	default:
+		return errGarbage
+	}
+	if r.err != nil {
+		return errGarbage
+	}
+
+	key := ""
+	if st == nfs3OK {
+		st = op.change(op.x.user(req.cred))
+	}
+	if st == nfs3OK {
+		key = op.dir.key + op.name
+		st = c.createFile(ctx, op, key, how, truncateTo, verifier)
+	}
+	c.created(ctx, w, op, st, key)
+	return nil
+}
+
+func (c *conn) createFile(ctx context.Context, op dirop, key string, how uint32, truncateTo *uint64, verifier [8]byte) uint32 {
+	s := c.server
+	if op.l.entries[key+directory.Separator] != nil {
+		return nfs3ErrExist
+	}
+	if existing := op.l.entries[key]; existing != nil {
+		switch how {
+		case createGuarded:
+			return nfs3ErrExist
+		case createExclusive:
+			s.mu.Lock()
+			retried := s.exclusive[key] == verifier
+			s.mu.Unlock()
+			if !retried {
+				return nfs3ErrExist
+			}
+			return nfs3OK
+		}
+		if truncateTo != nil && *truncateTo == 0 {
+			s.discard(key)
+			if err := s.store.Write(ctx, key, nil); err != nil {
+				return status(err)
+			}
+			s.changed(key)
+		}
+		return nfs3OK
+	}
+
+	if err := s.store.Write(ctx, key, nil); err != nil {
+		return status(err)
+	}
+	s.changed(key)
+	if how == createExclusive {
+		s.mu.Lock()
+		s.exclusive[key] = verifier
+		s.mu.Unlock()
+	}
+	return nfs3OK
+}
+
+func (c *conn) mkdir(ctx context.Context, req *call, w *writer) error {
+	op, st, err := c.readDirop(ctx, req.args)
+	if err != nil {
+		return err
+	}
+	r := req.args
+	for range 3 {
+		if r.bool() {
+			r.uint32()
+		}
+	}
+	if r.bool() {
+		r.uint64()
+	}
+	for range 2 {
+		if r.uint32() == setToClientTime {
+			r.uint64()
+		}
+	}
+	if r.err != nil {
+		return errGarbage
+	}
+
+	key := ""
+	if st == nfs3OK {
+		st = op.change(op.x.user(req.cred))
+	}
+	if st == nfs3OK {
+		key = op.dir.key + op.name + directory.Separator
+		if op.l.lookup(op.dir, op.name) != nil {
+			st = nfs3ErrExist
+		} else if err := c.server.store.Mkdir(ctx, key); err != nil {
+			st = status(err)
+		} else {
+			c.server.changed(key)
+		}
+	}
+	c.created(ctx, w, op, st, key)
+	return nil
+}
+
+// notSupported fails SYMLINK and MKNOD
+func (c *conn) notSupported(_ context.Context, _ *call, w *writer) error {
+	w.uint32(nfs3ErrNotSupp)
+	c.server.wcc(w, nil, nil)
+	return nil
+}
+
+// link fails, there being no hard links
+func (c *conn) link(_ context.Context, _ *call, w *writer) error {
+	w.uint32(nfs3ErrNotSupp)
+	w.bool(false)
+	c.server.wcc(w, nil, nil)
+	return nil
+}
+
+func (c *conn) remove(ctx context.Context, req *call, w *writer) error {
+	op, st, err := c.readDirop(ctx, req.args)
+	if err != nil {
+		return err
+	}
+	if st == nfs3OK {
+		st = op.change(op.x.user(req.cred))
+	}
+	if st == nfs3OK {
+		key := op.dir.key + op.name
+		switch {
+		case op.l.entries[key+directory.Separator] != nil:
+			st = nfs3ErrIsDir
+		case op.l.entries[key] == nil:
+			st = nfs3ErrNoEnt
+		default:
+			c.server.discard(key)
+			if err := c.server.store.Delete(ctx, key); err != nil {
+				st = status(err)
+			}
+			c.server.changed(key)
+		}
+	}
+	w.uint32(st)
+	c.dirWcc(ctx, w, op)
+	return nil
+}
+
+func (c *conn) rmdir(ctx context.Context, req *call, w *writer) error {
+	op, st, err := c.readDirop(ctx, req.args)
+	if err != nil {
+		return err
+	}
+	if st == nfs3OK {
+		st = op.change(op.x.user(req.cred))
+	}
+	if st == nfs3OK {
+		key := op.dir.key + op.name + directory.Separator
+		switch {
+		case op.l.entries[key] == nil && op.l.entries[op.dir.key+op.name] != nil:
+			st = nfs3ErrNotDir
+		case op.l.entries[key] == nil:
+			st = nfs3ErrNoEnt
+		case len(op.l.children[key]) > 0:
+			st = nfs3ErrNotEmpty
+		default:
+			report, err := c.server.tree.Delete(ctx, key, false)
+			if err == nil && report.Failed > 0 {
+				err = errors.New(report.Errors[0])
+			}
+			if err != nil {
+				st = status(err)
+			}
+			c.server.changed(key)
+		}
+	}
+	w.uint32(st)
+	c.dirWcc(ctx, w, op)
+	return nil
+}
+
+func (c *conn) rename(ctx context.Context, req *call, w *writer) error {
+	from, st, err := c.readDirop(ctx, req.args)
+	if err != nil {
+		return err
+	}
+	to, toSt, err := c.readDirop(ctx, req.args)
+	if err != nil {
+		return err
+	}
+	if st == nfs3OK {
+		st = toSt
+	}
+	if st == nfs3OK && from.x != to.x {
+		st = nfs3ErrXDev
+	}
+	if st == nfs3OK {
+		st = from.change(from.x.user(req.cred))
+	}
+	if st == nfs3OK {
+		st = to.change(to.x.user(req.cred))
+	}
+	if st == nfs3OK {
+		st = c.move(ctx, from, to)
+	}
+	w.uint32(st)
+	c.dirWcc(ctx, w, from)
+	c.dirWcc(ctx, w, to)
+	return nil
+}
+
+func (c *conn) move(ctx context.Context, from, to dirop) uint32 {
+	s := c.server
+	src := from.l.lookup(from.dir, from.name)
+	if src == nil {
+		return nfs3ErrNoEnt
+	}
+	dst := to.dir.key + to.name
+	if src.dir {
+		dst += directory.Separator
+	}
+	if src.key == dst {
+		return nfs3OK
+	}
+
+	// A file may replace a file, and a directory an empty directory
+	if existing := to.l.lookup(to.dir, to.name); existing != nil {
+		switch {
+		case src.dir && !existing.dir:
+			return nfs3ErrNotDir
+		case !src.dir && existing.dir:
+			return nfs3ErrIsDir
+		case existing.dir && len(to.l.children[existing.key]) > 0:
+			return nfs3ErrNotEmpty
+		case existing.dir:
+			if _, err := s.tree.Delete(ctx, existing.key, false); err != nil {
+				return status(err)
+			}
+		default:
+			s.discard(existing.key)
+		}
+	}
+
+	if err := s.flushBelow(ctx, src.key); err != nil {
+		return status(err)
+	}
+	report, err := s.tree.Move(ctx, src.key, dst, true)
+	s.changed(src.key)
+	s.changed(dst)
+	if err == nil && report.Failed > 0 {
+		err = errors.New(report.Errors[0])
+	}
+	if err != nil {
+		return status(err)
+	}
+	return nfs3OK
+}
+
+// directoryEntries returns the entries of a listed directory, "." and ".."
+// first. The cookie of an entry is its index plus one.
+func directoryEntries(l *listing, dir *entry) []*entry {
+	return append([]*entry{dir, l.parent(dir)}, l.children[dir.key]...)
+}
+
+func entryName(i int, e *entry) string {
+	switch i {
+	case 0:
+		return "."
+	case 1:
+		return ".."
+	}
+	return e.name()
+}
+
+// listDir checks a READDIR or READDIRPLUS of fh
+func (c *conn) listDir(ctx context.Context, req *call, fh []byte) (*export, *listing, *entry, uint32) {
+	x, l, e, st := c.resolve(ctx, fh)
+	switch {
+	case st != nfs3OK:
+	case !e.dir:
+		st = nfs3ErrNotDir
+	case x.access(x.user(req.cred), e)&accessRead == 0:
+		st = nfs3ErrAcces
+	}
+	return x, l, e, st
+}
+
+func (c *conn) readdir(ctx context.Context, req *call, w *writer) error {
+	r := req.args
+	fh := r.opaque(maxHandle)
+	cookie := r.uint64()
+	r.fixed(8) // cookie verifier
+	count := r.uint32()
+	if r.err != nil {
+		return errGarbage
+	}
+	x, l, dir, st := c.listDir(ctx, req, fh)
+	w.uint32(st)
+	if st != nfs3OK {
+		if x == nil {
+			w.bool(false)
+		} else {
+			c.server.postOp(w, x, dir)
+		}
+		return nil
+	}
+
+	start := len(w.buf) - 4
+	c.server.postOp(w, x, dir)
+	w.fixed(make([]byte, 8)) // cookie verifier
+	entries := directoryEntries(l, dir)
+	size := dirListOverhead
+	i := min(int(cookie), len(entries))
+	for ; i < len(entries); i++ {
+		name := entryName(i, entries[i])
+		n := 4 + 8 + 4 + len(name) + pad(len(name)) + 8
+		if size+n > int(count) {
+			break
+		}
+		size += n
+		w.bool(true)
+		w.uint64(entries[i].fileid())
+		w.string(name)
+		w.uint64(uint64(i + 1))
+	}
+	if i == int(cookie) && i < len(entries) {
+		w.buf = w.buf[:start]
+		w.uint32(nfs3ErrTooSmall)
+		c.server.postOp(w, x, dir)
+		return nil
+	}
+	w.bool(false)
+	w.bool(i == len(entries))
+	return nil
+}
+
+func (c *conn) readdirplus(ctx context.Context, req *call, w *writer) error {
+	r := req.args
+	fh := r.opaque(maxHandle)
+	cookie := r.uint64()
+	r.fixed(8) // cookie verifier
+	dircount, maxcount := r.uint32(), r.uint32()
+	if r.err != nil {
+		return errGarbage
+	}
+	x, l, dir, st := c.listDir(ctx, req, fh)
+	w.uint32(st)
+	if st != nfs3OK {
+		if x == nil {
+			w.bool(false)
+		} else {
+			c.server.postOp(w, x, dir)
+		}
+		return nil
+	}
+
+	start := len(w.buf) - 4
+	c.server.postOp(w, x, dir)
+	w.fixed(make([]byte, 8)) // cookie verifier
+	entries := directoryEntries(l, dir)
+	size, names := dirListOverhead, 0
+	i := min(int(cookie), len(entries))
+	for ; i < len(entries); i++ {
+		e, name := entries[i], entryName(i, entries[i])
+		n := 8 + 4 + len(name) + pad(len(name)) + 8
+		if names+n > int(dircount) || size+4+n+postOpAttrSize+postOpFileSize > int(maxcount) {
+			break
+		}
+		names += n
+		size += 4 + n + postOpAttrSize + postOpFileSize
+		w.bool(true)
+		w.uint64(e.fileid())
+		w.string(name)
+		w.uint64(uint64(i + 1))
+		c.server.postOp(w, x, e)
+		w.bool(true)
+		w.opaque(x.handle(e))
+	}
+	if i == int(cookie) && i < len(entries) {
+		w.buf = w.buf[:start]
+		w.uint32(nfs3ErrTooSmall)
+		c.server.postOp(w, x, dir)
+		return nil
+	}
+	w.bool(false)
+	w.bool(i == len(entries))
+	return nil
+}
+
+func (c *conn) fsstat(ctx context.Context, req *call, w *writer) error {
+	fh := req.args.opaque(maxHandle)
+	if req.args.err != nil {
+		return errGarbage
+	}
+	x, l, e, st := c.resolve(ctx, fh)
+	w.uint32(st)
+	if st != nfs3OK {
+		w.bool(false)
+		return nil
+	}
+	c.server.postOp(w, x, e)
+	w.uint64(l.bytes + freeBytes)
+	w.uint64(freeBytes)
+	w.uint64(freeBytes)
+	w.uint64(l.files + freeFiles)
+	w.uint64(freeFiles)
+	w.uint64(freeFiles)
+	w.uint32(0) // invarsec
+	return nil
+}
+
+func (c *conn) fsinfo(ctx context.Context, req *call, w *writer) error {
+	fh := req.args.opaque(maxHandle)
+	if req.args.err != nil {
+		return errGarbage
+	}
+	x, _, e, st := c.resolve(ctx, fh)
+	w.uint32(st)
+	if st != nfs3OK {
+		w.bool(false)
+		return nil
+	}
+	c.server.postOp(w, x, e)
+	w.uint32(maxIO) // rtmax
+	w.uint32(maxIO) // rtpref
+	w.uint32(4096)  // rtmult
+	w.uint32(maxIO) // wtmax
+	w.uint32(maxIO) // wtpref
+	w.uint32(4096)  // wtmult
+	w.uint32(64 << 10)
+	w.uint64(uint64(c.server.config.MaxFileSize))
+	w.uint32(0) // time_delta
+	w.uint32(1)
+	w.uint32(0x08) // FSF3_HOMOGENEOUS
+	return nil
+}
+
+func (c *conn) pathconf(ctx context.Context, req *call, w *writer) error {
+	fh := req.args.opaque(maxHandle)
+	if req.args.err != nil {
+		return errGarbage
+	}
+	x, _, e, st := c.resolve(ctx, fh)
+	w.uint32(st)
+	if st != nfs3OK {
+		w.bool(false)
+		return nil
+	}
+	c.server.postOp(w, x, e)
+	w.uint32(1)       // linkmax
+	w.uint32(maxName) // name_max
+	w.bool(true)      // no_trunc
+	w.bool(true)      // chown_restricted
+	w.bool(false)     // case_insensitive
+	w.bool(true)      // case_preserving
+	return nil
+}
+
+// commit stores the writes buffered for a file
+func (c *conn) commit(ctx context.Context, req *call, w *writer) error {
+	fh := req.args.opaque(maxHandle)
+	req.args.uint64()
+	req.args.uint32()
+	if req.args.err != nil {
+		return errGarbage
+	}
+	x, _, e, st := c.resolve(ctx, fh)
+	if st == nfs3OK && !e.dir {
+		if err := c.server.flush(ctx, e.key); err != nil {
+			st = status(err)
+		}
+	}
+	w.uint32(st)
+	if x == nil {
+		c.server.wcc(w, nil, nil)
+		return nil
+	}
+	c.server.wcc(w, x, c.current(ctx, x, e))
+	if st == nfs3OK {
+		w.fixed(c.server.verifier[:])
+	}
+	return nil
+}
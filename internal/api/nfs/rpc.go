@@ -0,0 +1,199 @@
+package nfs
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ONC RPC (RFC 5531) over TCP, where each message is a record of one or
+// more fragments (record marking).
+
+const (
+	rpcVersion = 2
+
+	msgCall  = 0
+	msgReply = 1
+
+	replyAccepted = 0
+	replyDenied   = 1
+
+	acceptSuccess      = 0
+	acceptProgUnavail  = 1
+	acceptProgMismatch = 2
+	acceptProcUnavail  = 3
+	acceptGarbageArgs  = 4
+	acceptSystemErr    = 5
+
+	rejectRPCMismatch = 0
+	rejectAuthError   = 1
+
+	authNone    = 0
+	authSys     = 1
+	authBadCred = 1
+
+	// lastFragment marks the last fragment of a record
+	lastFragment = 1 << 31
+	// maxRecordSize is the largest call in bytes, a write of maxIO bytes
+	// and its arguments
+	maxRecordSize = maxIO + 4096
+)
+
+// credentials are the user a call is made as. Calls without AUTH_SYS
+// credentials are made as the anonymous user.
+type credentials struct {
+	uid  uint32
+	gid  uint32
+	gids []uint32
+	// anonymous is set for calls without credentials
+	anonymous bool
+}
+
+// call is an RPC call
+type call struct {
+	xid  uint32
+	prog uint32
+	vers uint32
+	proc uint32
+	cred credentials
+	args *reader
+}
+
+// procedure answers a call, writing its results to w. errGarbage makes
+// the reply GARBAGE_ARGS; other errors make it SYSTEM_ERR.
+type procedure func(c *conn, ctx context.Context, req *call, w *writer) error
+
+// program is an RPC program of one version
+type program struct {
+	version    uint32
+	procedures map[uint32]procedure
+}
+
+// readRecord reads the fragments of a record
+func readRecord(r *bufio.Reader) ([]byte, error) {
+	var record []byte
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, err
+		}
+		mark := binary.BigEndian.Uint32(header[:])
+		n := int(mark &^ lastFragment)
+		if len(record)+n > maxRecordSize {
+			return nil, fmt.Errorf("record larger than %d bytes", maxRecordSize)
+		}
+		start := len(record)
+		record = append(record, make([]byte, n)...)
+		if _, err := io.ReadFull(r, record[start:]); err != nil {
+			return nil, err
+		}
+		if mark&lastFragment != 0 {
+			return record, nil
+		}
+	}
+}
+
+// writeRecord writes a reply as one fragment
+func writeRecord(w *bufio.Writer, reply []byte) error {
+	w.Write(binary.BigEndian.AppendUint32(nil, lastFragment|uint32(len(reply))))
+	w.Write(reply)
+	return w.Flush()
+}
+
+// parseCredentials reads the credentials of a call. AUTH_NONE calls are
+// anonymous; other flavors than AUTH_SYS are rejected.
+func parseCredentials(flavor uint32, body []byte) (credentials, bool) {
+	switch flavor {
+	case authNone:
+		return credentials{anonymous: true}, true
+	case authSys:
+		r := &reader{buf: body}
+		r.uint32()    // stamp
+		r.opaque(255) // machine name
+		cred := credentials{uid: r.uint32(), gid: r.uint32()}
+		n := r.uint32()
+		if n > 16 {
+			return credentials{}, false
+		}
+		for range n {
+			cred.gids = append(cred.gids, r.uint32())
+		}
+		return cred, r.err == nil
+	default:
+		return credentials{}, false
+	}
+}
+
+// answer answers the call in record. It returns nil for records that are
+// not calls, which are dropped.
+func (c *conn) answer(ctx context.Context, record []byte) []byte {
+	r := &reader{buf: record}
+	req := &call{xid: r.uint32()}
+	if r.uint32() != msgCall {
+		return nil
+	}
+	version := r.uint32()
+	req.prog, req.vers, req.proc = r.uint32(), r.uint32(), r.uint32()
+	flavor, body := r.uint32(), r.opaque(400)
+	r.uint32()    // verifier flavor
+	r.opaque(400) // verifier
+	if r.err != nil {
+		return nil
+	}
+	req.args = r
+
+	w := &writer{}
+	w.uint32(req.xid)
+	w.uint32(msgReply)
+	if version != rpcVersion {
+		w.uint32(replyDenied)
+		w.uint32(rejectRPCMismatch)
+		w.uint32(rpcVersion)
+		w.uint32(rpcVersion)
+		return w.buf
+	}
+	cred, ok := parseCredentials(flavor, body)
+	if !ok {
+		w.uint32(replyDenied)
+		w.uint32(rejectAuthError)
+		w.uint32(authBadCred)
+		return w.buf
+	}
+	req.cred = cred
+
+	w.uint32(replyAccepted)
+	w.uint32(authNone) // verifier
+	w.uint32(0)
+	prog, ok := programs[req.prog]
+	switch {
+	case !ok:
+		w.uint32(acceptProgUnavail)
+		return w.buf
+	case req.vers != prog.version:
+		w.uint32(acceptProgMismatch)
+		w.uint32(prog.version)
+		w.uint32(prog.version)
+		return w.buf
+	}
+	proc, ok := prog.procedures[req.proc]
+	if !ok {
+		w.uint32(acceptProcUnavail)
+		return w.buf
+	}
+
+	start := len(w.buf)
+	w.uint32(acceptSuccess)
+	if err := proc(c, ctx, req, w); err != nil {
+		w.buf = w.buf[:start]
+		if errors.Is(err, errGarbage) {
+			w.uint32(acceptGarbageArgs)
+		} else {
+			c.server.logger.Error("NFS call failed", "program", req.prog, "procedure", req.proc, "error", err)
+			w.uint32(acceptSystemErr)
+		}
+	}
+	return w.buf
+}
@@ -0,0 +1,310 @@
+// Package nfs serves namespaces of the file store as NFSv3 exports (RFC
+// 1813), so systems that can only speak NFS read and write PeerVault
+// files.
+//
+// An export shows the keys below its namespace as a tree: keys ending in
+// "/" and the folders keys are in are directories, other keys are files.
+// MOUNT v3 and NFS v3 are served over TCP on the same port, so clients
+// mount with
+//
+//	mount -t nfs -o vers=3,proto=tcp,port=2049,mountport=2049,mountproto=tcp,nolock host:/data /mnt/data
+//
+// The store has no owners or permission bits: every file and directory of
+// an export is owned by its UID and GID and has its FileMode or DirMode.
+// The AUTH_SYS user of a call is checked against them after squashing, by
+// default every user is the anonymous user. Writes are buffered in memory
+// and stored as a new version of the file when the client commits them,
+// writes stably, or stops writing for WriteDelay. Symbolic links, hard
+// links, special files and locking (NLM) are not supported.
+package nfs
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/directory"
+)
+
+// maxIO is the largest read or write in bytes
+const maxIO = 1 << 20
+
+// nobody is the default user and group of exports
+const nobody = 65534
+
+// Squash is how the users of clients are mapped
+type Squash string
+
+const (
+	// SquashAll treats every user as the anonymous user
+	SquashAll Squash = "all"
+	// SquashRoot treats root as the anonymous user
+	SquashRoot Squash = "root"
+	// SquashNone trusts the users clients send
+	SquashNone Squash = "none"
+)
+
+// Export is a namespace served to NFS clients
+type Export struct {
+	// Path is what clients mount, e.g. "/data"
+	Path string
+	// Namespace is the key prefix shown, e.g. "teams/data/"; empty shows
+	// every key
+	Namespace string
+	// ReadOnly rejects every change
+	ReadOnly bool
+	// Clients are the addresses and CIDR ranges allowed to mount; empty
+	// allows any client
+	Clients []string
+	// Squash maps the users of clients
+	Squash Squash
+	// UID and GID own every file and directory
+	UID uint32
+	GID uint32
+	// AnonUID and AnonGID are the anonymous user
+	AnonUID uint32
+	AnonGID uint32
+	// FileMode and DirMode are the permission bits of files and
+	// directories, e.g. 0644
+	FileMode uint32
+	DirMode  uint32
+}
+
+// DefaultExport returns an export of namespace at path where every client
+// acts as nobody, which owns every file
+func DefaultExport(path, namespace string) Export {
+	return Export{
+		Path:      path,
+		Namespace: namespace,
+		Squash:    SquashAll,
+		UID:       nobody,
+		GID:       nobody,
+		AnonUID:   nobody,
+		AnonGID:   nobody,
+		FileMode:  0o664,
+		DirMode:   0o775,
+	}
+}
+
+// Config holds the NFS server configuration
+type Config struct {
+	Exports []Export
+	// AttrCacheTTL is how long listings and attributes are reused before
+	// the store is listed again; 0 lists it for every call. Changes made
+	// through the server are seen at once.
+	AttrCacheTTL time.Duration
+	// WriteDelay stores the writes to a file once it was not written for
+	// this long
+	WriteDelay time.Duration
+	// MaxFileSize is the largest file in bytes clients may write; files
+	// being written are held in memory
+	MaxFileSize int64
+}
+
+// DefaultConfig returns the default NFS server configuration, without
+// exports
+func DefaultConfig() *Config {
+	return &Config{
+		AttrCacheTTL: 3 * time.Second,
+		WriteDelay:   5 * time.Second,
+		MaxFileSize:  256 << 20,
+	}
+}
+
+// Store holds the files served, see directory.Store
+type Store interface {
+	directory.Store
+	// Read returns the content of a file
+	Read(ctx context.Context, key string) ([]byte, error)
+	// Write stores the content of a file, replacing it
+	Write(ctx context.Context, key string, data []byte) error
+}
+
+// Server answers NFS clients
+type Server struct {
+	store   Store
+	tree    *directory.Tree
+	config  *Config
+	logger  *slog.Logger
+	exports []*export
+	// verifier changes when the server restarts, so clients resend the
+	// writes they did not commit
+	verifier [8]byte
+
+	// flushMu serializes storing pending files
+	flushMu sync.Mutex
+	mu      sync.Mutex
+	pending map[string]*pending
+	// exclusive holds the verifiers of exclusive creates, so retries of
+	// them succeed
+	exclusive map[string][8]byte
+	cache     readCache
+}
+
+// NewServer creates an NFS server for the exports of config
+func NewServer(store Store, config *Config, logger *slog.Logger) (*Server, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	s := &Server{
+		store:     store,
+		tree:      directory.New(store),
+		config:    config,
+		logger:    logger,
+		pending:   make(map[string]*pending),
+		exclusive: make(map[string][8]byte),
+	}
+	binary.BigEndian.PutUint64(s.verifier[:], uint64(time.Now().UnixNano()))
+
+	for _, e := range config.Exports {
+		x, err := newExport(e)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range s.exports {
+			if other.Path == x.Path {
+				return nil, fmt.Errorf("nfs: export %s is listed twice", x.Path)
+			}
+		}
+		s.exports = append(s.exports, x)
+	}
+	return s, nil
+}
+
+func newExport(e Export) (*export, error) {
+	if !strings.HasPrefix(e.Path, "/") || path.Clean(e.Path) != e.Path {
+		return nil, fmt.Errorf("nfs: export path %q must be an absolute, clean path", e.Path)
+	}
+	namespace, err := directory.CleanDir(e.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("nfs: export %s: %w", e.Path, err)
+	}
+	e.Namespace = namespace
+	switch e.Squash {
+	case "":
+		e.Squash = SquashAll
+	case SquashAll, SquashRoot, SquashNone:
+	default:
+		return nil, fmt.Errorf("nfs: export %s: unknown squash %q", e.Path, e.Squash)
+	}
+	if e.FileMode > 0o777 || e.DirMode > 0o777 {
+		return nil, fmt.Errorf("nfs: export %s: modes are permission bits up to 0777", e.Path)
+	}
+
+	x := &export{Export: e}
+	sum := sha256.Sum256([]byte(e.Path))
+	copy(x.id[:], sum[:])
+	for _, c := range e.Clients {
+		if _, network, err := net.ParseCIDR(c); err == nil {
+			x.clients = append(x.clients, network)
+			continue
+		}
+		ip := net.ParseIP(c)
+		if ip == nil {
+			return nil, fmt.Errorf("nfs: export %s: invalid client %q", e.Path, c)
+		}
+		bits := 8 * len(ip.To16())
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		x.clients = append(x.clients, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return x, nil
+}
+
+// Serve answers the clients accepting on listener until ctx is done.
+// Buffered writes are stored before it returns.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	flushed := make(chan struct{})
+	flushCtx, stopFlushing := context.WithCancel(context.Background())
+	go func() {
+		defer close(flushed)
+		s.flushIdle(flushCtx)
+	}()
+	defer func() {
+		stopFlushing()
+		<-flushed
+		s.flushAll(context.Background())
+	}()
+
+	// Connections are closed when Serve returns, and waited for
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+	for {
+		nc, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(connCtx, nc)
+		}()
+	}
+}
+
+// conn is the connection of a client
+type conn struct {
+	server *Server
+	net.Conn
+	ip net.IP
+}
+
+func (s *Server) serveConn(ctx context.Context, nc net.Conn) {
+	stop := context.AfterFunc(ctx, func() { nc.Close() })
+	defer stop()
+	defer nc.Close()
+
+	c := &conn{server: s, Conn: nc}
+	if addr, ok := nc.RemoteAddr().(*net.TCPAddr); ok {
+		c.ip = addr.IP
+	}
+	r := bufio.NewReader(nc)
+	w := bufio.NewWriter(nc)
+	for {
+		record, err := readRecord(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && ctx.Err() == nil {
+				s.logger.Debug("Closing NFS connection", "error", err, "remote", nc.RemoteAddr())
+			}
+			return
+		}
+		reply := c.answer(ctx, record)
+		if reply == nil {
+			continue
+		}
+		if err := writeRecord(w, reply); err != nil {
+			return
+		}
+	}
+}
+
+// lookupExport returns the export mounted at path
+func (s *Server) lookupExport(path string) *export {
+	for _, x := range s.exports {
+		if x.Path == path {
+			return x
+		}
+	}
+	return nil
+}
@@ -0,0 +1,569 @@
+package nfs
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore is a Store keeping content in memory
+type memStore struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	mtime map[string]time.Time
+}
+
+func newMemStore(files map[string]string) *memStore {
+	s := &memStore{files: make(map[string][]byte), mtime: make(map[string]time.Time)}
+	for k, v := range files {
+		s.files[k] = []byte(v)
+		s.mtime[k] = time.Now()
+	}
+	return s
+}
+
+func (s *memStore) List(ctx context.Context, prefix string) ([]directory.Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var objects []directory.Object
+	for k, data := range s.files {
+		if strings.HasPrefix(k, prefix) {
+			objects = append(objects, directory.Object{Key: k, Size: int64(len(data)), ModTime: s.mtime[k]})
+		}
+	}
+	return objects, nil
+}
+
+func (s *memStore) Copy(ctx context.Context, from, to string, overwrite bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[from]
+	if !ok {
+		return directory.ErrNotFound
+	}
+	if _, ok := s.files[to]; ok && !overwrite {
+		return directory.ErrExists
+	}
+	s.files[to], s.mtime[to] = data, time.Now()
+	return nil
+}
+
+func (s *memStore) Rename(ctx context.Context, from, to string, overwrite bool) error {
+	if err := s.Copy(ctx, from, to, overwrite); err != nil {
+		return err
+	}
+	return s.Delete(ctx, from)
+}
+
+func (s *memStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[key]; !ok {
+		return directory.ErrNotFound
+	}
+	delete(s.files, key)
+	return nil
+}
+
+func (s *memStore) Mkdir(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[key]; ok {
+		return directory.ErrExists
+	}
+	s.files[key], s.mtime[key] = nil, time.Now()
+	return nil
+}
+
+func (s *memStore) Read(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[key]
+	if !ok {
+		return nil, directory.ErrNotFound
+	}
+	return data, nil
+}
+
+func (s *memStore) Write(ctx context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[key], s.mtime[key] = data, time.Now()
+	return nil
+}
+
+func (s *memStore) get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[key]
+	return string(data), ok
+}
+
+// client calls the server the way NFS clients do, as a user
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	xid  uint32
+	uid  uint32
+	gid  uint32
+}
+
+func (c *client) call(prog, proc uint32, args func(w *writer)) *reader {
+	c.t.Helper()
+	c.xid++
+	cred := &writer{}
+	cred.uint32(0)
+	cred.string("test")
+	cred.uint32(c.uid)
+	cred.uint32(c.gid)
+	cred.uint32(0)
+
+	w := &writer{}
+	w.uint32(c.xid)
+	w.uint32(msgCall)
+	w.uint32(rpcVersion)
+	w.uint32(prog)
+	w.uint32(3)
+	w.uint32(proc)
+	w.uint32(authSys)
+	w.opaque(cred.buf)
+	w.uint32(authNone)
+	w.opaque(nil)
+	args(w)
+
+	require.NoError(c.t, c.conn.SetDeadline(time.Now().Add(5*time.Second)))
+	bw := bufio.NewWriter(c.conn)
+	require.NoError(c.t, writeRecord(bw, w.buf))
+	record, err := readRecord(c.r)
+	require.NoError(c.t, err)
+	r := &reader{buf: record}
+	require.Equal(c.t, c.xid, r.uint32())
+	require.Equal(c.t, uint32(msgReply), r.uint32())
+	require.Equal(c.t, uint32(replyAccepted), r.uint32())
+	r.uint32()
+	r.opaque(400)
+	require.Equal(c.t, uint32(acceptSuccess), r.uint32())
+	return r
+}
+
+// mount returns the handle of path
+func (c *client) mount(path string) (uint32, []byte) {
+	c.t.Helper()
+	r := c.call(mountProgram, mountProcMnt, func(w *writer) { w.string(path) })
+	if st := r.uint32(); st != mnt3OK {
+		return st, nil
+	}
+	return mnt3OK, r.opaque(maxHandle)
+}
+
+// attrs are the attributes of fattr3 tests look at
+type attrs struct {
+	typ  uint32
+	mode uint32
+	uid  uint32
+	gid  uint32
+	size uint64
+}
+
+func readAttrs(r *reader) attrs {
+	a := attrs{typ: r.uint32(), mode: r.uint32()}
+	r.uint32() // nlink
+	a.uid, a.gid, a.size = r.uint32(), r.uint32(), r.uint64()
+	r.take(attrSize - 28)
+	return a
+}
+
+func (c *client) lookup(dir []byte, name string) (uint32, []byte, attrs) {
+	c.t.Helper()
+	r := c.call(nfsProgram, 3, func(w *writer) {
+		w.opaque(dir)
+		w.string(name)
+	})
+	if st := r.uint32(); st != nfs3OK {
+		return st, nil, attrs{}
+	}
+	fh := r.opaque(maxHandle)
+	require.True(c.t, r.bool())
+	return nfs3OK, fh, readAttrs(r)
+}
+
+func (c *client) read(fh []byte, offset uint64, count uint32) (uint32, string, bool) {
+	c.t.Helper()
+	r := c.call(nfsProgram, 6, func(w *writer) {
+		w.opaque(fh)
+		w.uint64(offset)
+		w.uint32(count)
+	})
+	st := r.uint32()
+	if r.bool() {
+		readAttrs(r)
+	}
+	if st != nfs3OK {
+		return st, "", false
+	}
+	r.uint32()
+	eof := r.bool()
+	return st, string(r.opaque(maxIO)), eof
+}
+
+func (c *client) write(fh []byte, offset uint64, data string, stable uint32) uint32 {
+	c.t.Helper()
+	r := c.call(nfsProgram, 7, func(w *writer) {
+		w.opaque(fh)
+		w.uint64(offset)
+		w.uint32(uint32(len(data)))
+		w.uint32(stable)
+		w.string(data)
+	})
+	return r.uint32()
+}
+
+// create creates name in dir, guarded, and returns its handle
+func (c *client) create(dir []byte, name string) (uint32, []byte) {
+	c.t.Helper()
+	r := c.call(nfsProgram, 8, func(w *writer) {
+		w.opaque(dir)
+		w.string(name)
+		w.uint32(createGuarded)
+		for range 4 {
+			w.bool(false)
+		}
+		w.uint32(0)
+		w.uint32(0)
+	})
+	st := r.uint32()
+	if st != nfs3OK {
+		return st, nil
+	}
+	require.True(c.t, r.bool())
+	return st, r.opaque(maxHandle)
+}
+
+// dirop calls a procedure taking diropargs3 and returns its status
+func (c *client) dirop(proc uint32, dir []byte, name string, args func(w *writer)) uint32 {
+	c.t.Helper()
+	return c.call(nfsProgram, proc, func(w *writer) {
+		w.opaque(dir)
+		w.string(name)
+		args(w)
+	}).uint32()
+}
+
+// readdir lists dir with READDIRPLUS
+func (c *client) readdir(dir []byte) []string {
+	c.t.Helper()
+	var names []string
+	cookie := uint64(0)
+	for {
+		r := c.call(nfsProgram, 17, func(w *writer) {
+			w.opaque(dir)
+			w.uint64(cookie)
+			w.fixed(make([]byte, 8))
+			w.uint32(200)
+			w.uint32(600)
+		})
+		require.Equal(c.t, uint32(nfs3OK), r.uint32())
+		require.True(c.t, r.bool())
+		readAttrs(r)
+		r.fixed(8)
+		for r.bool() {
+			r.uint64()
+			names = append(names, r.string(maxName))
+			cookie = r.uint64()
+			require.True(c.t, r.bool())
+			readAttrs(r)
+			require.True(c.t, r.bool())
+			r.opaque(maxHandle)
+		}
+		eof := r.bool()
+		require.NoError(c.t, r.err)
+		if eof {
+			return names
+		}
+	}
+}
+
+func startServer(t *testing.T, store *memStore, config *Config) func(uid, gid uint32) *client {
+	srv, err := NewServer(store, config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ctx, listener) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+	})
+
+	return func(uid, gid uint32) *client {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return &client{t: t, conn: conn, r: bufio.NewReader(conn), uid: uid, gid: gid}
+	}
+}
+
+func exportConfig(exports ...Export) *Config {
+	config := DefaultConfig()
+	config.Exports = exports
+	return config
+}
+
+func TestMountAndRead(t *testing.T) {
+	store := newMemStore(map[string]string{
+		"data/a.txt":     "hello",
+		"data/docs/b.md": "# b",
+		"other/c.txt":    "c",
+	})
+	data := DefaultExport("/data", "data")
+	data.Clients = []string{"127.0.0.1"}
+	config := exportConfig(data, DefaultExport("/all", ""))
+	c := startServer(t, store, config)(1000, 1000)
+
+	// The exports and the clients allowed to mount them
+	r := c.call(mountProgram, mountProcExport, func(*writer) {})
+	require.True(t, r.bool())
+	assert.Equal(t, "/data", r.string(maxPath))
+	require.True(t, r.bool())
+	assert.Equal(t, "127.0.0.1", r.string(maxPath))
+	assert.False(t, r.bool())
+	require.True(t, r.bool())
+	assert.Equal(t, "/all", r.string(maxPath))
+
+	st, _ := c.mount("/missing")
+	assert.Equal(t, uint32(mnt3ErrNoEnt), st)
+	st, docs := c.mount("/data/docs")
+	require.Equal(t, uint32(mnt3OK), st)
+	st, root := c.mount("/data")
+	require.Equal(t, uint32(mnt3OK), st)
+
+	st, fh, a := c.lookup(root, "a.txt")
+	require.Equal(t, uint32(nfs3OK), st)
+	assert.Equal(t, attrs{typ: typeRegular, mode: 0o664, uid: nobody, gid: nobody, size: 5}, a)
+	st, content, eof := c.read(fh, 1, 100)
+	require.Equal(t, uint32(nfs3OK), st)
+	assert.Equal(t, "ello", content)
+	assert.True(t, eof)
+	_, content, eof = c.read(fh, 0, 2)
+	assert.Equal(t, "he", content)
+	assert.False(t, eof)
+
+	st, _, _ = c.lookup(root, "c.txt")
+	assert.Equal(t, uint32(nfs3ErrNoEnt), st, "keys outside the namespace are not exported")
+	st, fh, a = c.lookup(docs, "b.md")
+	require.Equal(t, uint32(nfs3OK), st)
+	assert.Equal(t, uint64(3), a.size)
+	st, _, _ = c.lookup(fh, "x")
+	assert.Equal(t, uint32(nfs3ErrNotDir), st)
+
+	assert.Equal(t, []string{".", "..", "a.txt", "docs"}, c.readdir(root))
+
+	// Handles are bound to their export and stay valid across restarts
+	_, other := c.mount("/all")
+	st, _, _ = c.lookup(other, "other")
+	assert.Equal(t, uint32(nfs3OK), st)
+	r = c.call(nfsProgram, 1, func(w *writer) { w.opaque([]byte("bogus")) })
+	assert.Equal(t, uint32(nfs3ErrBadHandle), r.uint32())
+	restarted := startServer(t, store, config)(1000, 1000)
+	r = restarted.call(nfsProgram, 1, func(w *writer) { w.opaque(docs) })
+	require.Equal(t, uint32(nfs3OK), r.uint32())
+	assert.Equal(t, uint32(typeDirectory), readAttrs(r).typ)
+}
+
+func TestWrite(t *testing.T) {
+	store := newMemStore(map[string]string{"data/docs/b.md": "# b"})
+	c := startServer(t, store, exportConfig(DefaultExport("/data", "data/")))(1000, 1000)
+	_, root := c.mount("/data")
+
+	st, fh := c.create(root, "new.txt")
+	require.Equal(t, uint32(nfs3OK), st)
+	st, _ = c.create(root, "new.txt")
+	assert.Equal(t, uint32(nfs3ErrExist), st)
+
+	// Unstable writes are held until committed
+	require.Equal(t, uint32(nfs3OK), c.write(fh, 0, "abc", unstable))
+	require.Equal(t, uint32(nfs3OK), c.write(fh, 3, "def", unstable))
+	stored, _ := store.get("data/new.txt")
+	assert.Empty(t, stored)
+	_, _, a := c.lookup(root, "new.txt")
+	assert.Equal(t, uint64(6), a.size)
+	_, content, _ := c.read(fh, 0, 100)
+	assert.Equal(t, "abcdef", content)
+
+	r := c.call(nfsProgram, 21, func(w *writer) {
+		w.opaque(fh)
+		w.uint64(0)
+		w.uint32(0)
+	})
+	require.Equal(t, uint32(nfs3OK), r.uint32())
+	stored, _ = store.get("data/new.txt")
+	assert.Equal(t, "abcdef", stored)
+
+	// Stable writes are stored at once
+	require.Equal(t, uint32(nfs3OK), c.write(fh, 1, "X", fileSync))
+	stored, _ = store.get("data/new.txt")
+	assert.Equal(t, "aXcdef", stored)
+
+	// Truncating
+	r = c.call(nfsProgram, 2, func(w *writer) {
+		w.opaque(fh)
+		w.bool(false)
+		w.bool(false)
+		w.bool(false)
+		w.bool(true)
+		w.uint64(2)
+		w.uint32(0)
+		w.uint32(0)
+		w.bool(false)
+	})
+	require.Equal(t, uint32(nfs3OK), r.uint32())
+	stored, _ = store.get("data/new.txt")
+	assert.Equal(t, "aX", stored)
+
+	// Directories, renames and removes
+	noArgs := func(*writer) {}
+	mkdirArgs := func(w *writer) {
+		for range 4 {
+			w.bool(false)
+		}
+		w.uint32(0)
+		w.uint32(0)
+	}
+	require.Equal(t, uint32(nfs3OK), c.dirop(9, root, "sub", mkdirArgs))
+	_, ok := store.get("data/sub/")
+	assert.True(t, ok)
+	_, sub, _ := c.lookup(root, "sub")
+	require.Equal(t, uint32(nfs3OK), c.dirop(14, root, "new.txt", func(w *writer) {
+		w.opaque(sub)
+		w.string("moved.txt")
+	}))
+	stored, _ = store.get("data/sub/moved.txt")
+	assert.Equal(t, "aX", stored)
+	st, _, _ = c.lookup(root, "new.txt")
+	assert.Equal(t, uint32(nfs3ErrNoEnt), st)
+
+	assert.Equal(t, uint32(nfs3ErrNotEmpty), c.dirop(13, root, "sub", noArgs))
+	assert.Equal(t, uint32(nfs3ErrIsDir), c.dirop(12, root, "sub", noArgs))
+	require.Equal(t, uint32(nfs3OK), c.dirop(12, sub, "moved.txt", noArgs))
+	require.Equal(t, uint32(nfs3OK), c.dirop(13, root, "sub", noArgs))
+	assert.Equal(t, []string{".", "..", "docs"}, c.readdir(root))
+
+	// Folders move with everything below them
+	require.Equal(t, uint32(nfs3OK), c.dirop(14, root, "docs", func(w *writer) {
+		w.opaque(root)
+		w.string("papers")
+	}))
+	_, ok = store.get("data/papers/b.md")
+	assert.True(t, ok)
+}
+
+func TestIdleWritesAreStored(t *testing.T) {
+	store := newMemStore(map[string]string{"data/log.txt": "a"})
+	config := exportConfig(DefaultExport("/data", "data/"))
+	config.WriteDelay = 20 * time.Millisecond
+	c := startServer(t, store, config)(1000, 1000)
+	_, root := c.mount("/data")
+	_, fh, _ := c.lookup(root, "log.txt")
+
+	require.Equal(t, uint32(nfs3OK), c.write(fh, 1, "b", unstable))
+	assert.Eventually(t, func() bool {
+		stored, _ := store.get("data/log.txt")
+		return stored == "ab"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestAttrCache(t *testing.T) {
+	store := newMemStore(map[string]string{"data/a": "a"})
+	cached := exportConfig(DefaultExport("/data", "data/"))
+	cached.AttrCacheTTL = time.Hour
+	uncached := exportConfig(DefaultExport("/data", "data/"))
+	uncached.AttrCacheTTL = 0
+
+	withCache := startServer(t, store, cached)(1000, 1000)
+	withoutCache := startServer(t, store, uncached)(1000, 1000)
+	_, root := withCache.mount("/data")
+	withCache.lookup(root, "a")
+	require.NoError(t, store.Write(context.Background(), "data/b", []byte("b")))
+
+	st, _, _ := withCache.lookup(root, "b")
+	assert.Equal(t, uint32(nfs3ErrNoEnt), st, "listings are reused for the TTL")
+	st, _, _ = withoutCache.lookup(root, "b")
+	assert.Equal(t, uint32(nfs3OK), st)
+}
+
+func TestPermissions(t *testing.T) {
+	store := newMemStore(map[string]string{"data/a": "a", "archive/old": "old"})
+	owned := DefaultExport("/data", "data/")
+	owned.Squash = SquashRoot
+	owned.UID, owned.GID = 1000, 100
+	owned.FileMode, owned.DirMode = 0o640, 0o750
+	readOnly := DefaultExport("/archive", "archive/")
+	readOnly.ReadOnly = true
+	remote := DefaultExport("/remote", "data/")
+	remote.Clients = []string{"10.0.0.0/8"}
+	connect := startServer(t, store, exportConfig(owned, readOnly, remote))
+
+	access := func(c *client, fh []byte) uint32 {
+		r := c.call(nfsProgram, 4, func(w *writer) {
+			w.opaque(fh)
+			w.uint32(accessRead | accessModify | accessLookup)
+		})
+		require.Equal(t, uint32(nfs3OK), r.uint32())
+		require.True(t, r.bool())
+		readAttrs(r)
+		return r.uint32()
+	}
+
+	owner := connect(1000, 1000)
+	_, root := owner.mount("/data")
+	_, fh, a := owner.lookup(root, "a")
+	assert.Equal(t, uint32(1000), a.uid)
+	assert.Equal(t, uint32(accessRead|accessModify), access(owner, fh))
+
+	group := connect(2000, 100)
+	assert.Equal(t, uint32(accessRead), access(group, fh))
+	assert.Equal(t, uint32(nfs3ErrAcces), group.write(fh, 0, "x", fileSync))
+
+	// Root is squashed to nobody, who may not even look
+	root0 := connect(0, 0)
+	assert.Equal(t, uint32(0), access(root0, fh))
+	st, _, _ := root0.lookup(root, "a")
+	assert.Equal(t, uint32(nfs3ErrAcces), st)
+
+	_, archive := owner.mount("/archive")
+	_, old, _ := owner.lookup(archive, "old")
+	assert.Equal(t, uint32(nfs3ErrROFS), owner.write(old, 0, "x", fileSync))
+	st, _ = owner.create(archive, "new")
+	assert.Equal(t, uint32(nfs3ErrROFS), st)
+	assert.Equal(t, uint32(accessRead), access(owner, old))
+
+	st, _ = owner.mount("/remote")
+	assert.Equal(t, uint32(mnt3ErrAcces), st)
+}
+
+func TestNewServerValidatesExports(t *testing.T) {
+	for _, e := range []Export{
+		DefaultExport("data", ""),
+		DefaultExport("/data/", ""),
+		DefaultExport("/data", "../x"),
+		{Path: "/data", Squash: "some"},
+		{Path: "/data", Clients: []string{"host.example.com"}},
+	} {
+		_, err := NewServer(newMemStore(nil), exportConfig(e), nil)
+		assert.Error(t, err, "%+v", e)
+	}
+	_, err := NewServer(newMemStore(nil), exportConfig(DefaultExport("/a", ""), DefaultExport("/a", "b")), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,262 @@
+package nfs
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readCacheSize bounds the content kept by the read cache in bytes
+const readCacheSize = 64 << 20
+
+// pending is the content of a file being written
+type pending struct {
+	data    []byte
+	written time.Time
+	// version counts the changes; flushed is the last version stored
+	version uint64
+	flushed uint64
+}
+
+// modify changes the content of the file at key, reading it from the
+// store on the first change. It returns the new size.
+func (s *Server) modify(ctx context.Context, key string, change func([]byte) []byte) (int, error) {
+	for {
+		s.mu.Lock()
+		if p := s.pending[key]; p != nil {
+			p.data = change(p.data)
+			p.written = time.Now()
+			p.version++
+			n := len(p.data)
+			s.mu.Unlock()
+			return n, nil
+		}
+		s.mu.Unlock()
+
+		data, err := s.store.Read(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		s.mu.Lock()
+		if s.pending[key] == nil {
+			s.pending[key] = &pending{data: data}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// writeAt returns a change writing b at offset
+func writeAt(offset int64, b []byte) func([]byte) []byte {
+	return func(data []byte) []byte {
+		if end := int(offset) + len(b); end > len(data) {
+			data = append(data, make([]byte, end-len(data))...)
+		}
+		copy(data[offset:], b)
+		return data
+	}
+}
+
+// truncate returns a change setting the size
+func truncate(size int64) func([]byte) []byte {
+	return func(data []byte) []byte {
+		if int(size) <= len(data) {
+			return data[:size]
+		}
+		return append(data, make([]byte, int(size)-len(data))...)
+	}
+}
+
+// flush stores the pending content of the file at key
+func (s *Server) flush(ctx context.Context, key string) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	p := s.pending[key]
+	if p == nil || p.flushed == p.version {
+		s.mu.Unlock()
+		return nil
+	}
+	data, version := bytes.Clone(p.data), p.version
+	s.mu.Unlock()
+
+	if err := s.store.Write(ctx, key, data); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	p.flushed = version
+	if s.pending[key] == p && p.version == version {
+		delete(s.pending, key)
+	}
+	s.mu.Unlock()
+	s.changed(key)
+	return nil
+}
+
+// flushBelow stores the pending content of key and the files below it
+func (s *Server) flushBelow(ctx context.Context, key string) error {
+	for _, k := range s.pendingKeys(func(k string, _ *pending) bool { return strings.HasPrefix(k, key) }) {
+		if err := s.flush(ctx, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discard drops the pending content of the file at key
+func (s *Server) discard(key string) {
+	s.mu.Lock()
+	delete(s.pending, key)
+	s.mu.Unlock()
+}
+
+func (s *Server) pendingKeys(match func(string, *pending) bool) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k, p := range s.pending {
+		if match(k, p) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// flushIdle stores the files not written for WriteDelay until ctx is done
+func (s *Server) flushIdle(ctx context.Context) {
+	ticker := time.NewTicker(max(s.config.WriteDelay/2, 100*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		idle := s.pendingKeys(func(_ string, p *pending) bool { return time.Since(p.written) >= s.config.WriteDelay })
+		for _, key := range idle {
+			if err := s.flush(ctx, key); err != nil && ctx.Err() == nil {
+				s.logger.Error("Failed to store NFS writes", "key", key, "error", err)
+			}
+		}
+	}
+}
+
+// flushAll stores every pending file
+func (s *Server) flushAll(ctx context.Context) {
+	for _, key := range s.pendingKeys(func(string, *pending) bool { return true }) {
+		if err := s.flush(ctx, key); err != nil {
+			s.logger.Error("Failed to store NFS writes", "key", key, "error", err)
+		}
+	}
+}
+
+// overlay returns the size and modification time of e, those of its
+// pending content while it is being written
+func (s *Server) overlay(e *entry) (int64, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p := s.pending[e.key]; p != nil && !e.dir {
+		return int64(len(p.data)), p.written
+	}
+	return e.size, e.mtime
+}
+
+// read reads count bytes of the file of e at offset
+func (s *Server) read(ctx context.Context, e *entry, offset uint64, count uint32) ([]byte, bool, error) {
+	s.mu.Lock()
+	p := s.pending[e.key]
+	var data []byte
+	if p != nil {
+		data = bytes.Clone(p.data)
+	}
+	s.mu.Unlock()
+
+	if p == nil {
+		data = s.cache.get(e.key, e.mtime)
+		if data == nil {
+			var err error
+			if data, err = s.store.Read(ctx, e.key); err != nil {
+				return nil, false, err
+			}
+			s.cache.put(e.key, e.mtime, data)
+		}
+	}
+	if offset >= uint64(len(data)) {
+		return nil, true, nil
+	}
+	end := min(offset+uint64(count), uint64(len(data)))
+	return data[offset:end], end == uint64(len(data)), nil
+}
+
+// changed drops what is known about key after a change
+func (s *Server) changed(key string) {
+	s.cache.drop(key)
+	s.mu.Lock()
+	delete(s.exclusive, key)
+	s.mu.Unlock()
+	for _, x := range s.exports {
+		x.mu.Lock()
+		x.listing = nil
+		x.mu.Unlock()
+	}
+}
+
+// readCache keeps the content of recently read files, so a file read in
+// many calls is read from the store once
+type readCache struct {
+	mu sync.Mutex
+	// files are the least recently used first
+	files []cachedFile
+	size  int
+}
+
+type cachedFile struct {
+	key   string
+	mtime time.Time
+	data  []byte
+}
+
+func (c *readCache) get(key string, mtime time.Time) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, f := range c.files {
+		if f.key == key && f.mtime.Equal(mtime) {
+			c.files = append(append(c.files[:i:i], c.files[i+1:]...), f)
+			return f.data
+		}
+	}
+	return nil
+}
+
+func (c *readCache) put(key string, mtime time.Time, data []byte) {
+	if len(data) > readCacheSize {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+	for c.size+len(data) > readCacheSize {
+		c.size -= len(c.files[0].data)
+		c.files = c.files[1:]
+	}
+	c.files = append(c.files, cachedFile{key: key, mtime: mtime, data: data})
+	c.size += len(data)
+}
+
+func (c *readCache) drop(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+}
+
+func (c *readCache) remove(key string) {
+	for i, f := range c.files {
+		if f.key == key {
+			c.size -= len(f.data)
+			c.files = append(c.files[:i:i], c.files[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package nfs
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// errGarbage is returned for arguments that are not valid XDR
+var errGarbage = errors.New("nfs: malformed arguments")
+
+// reader decodes XDR (RFC 4506). The first error sticks: later reads
+// return zero values.
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.buf) {
+		r.err = errGarbage
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) uint32() uint32 {
+	b := r.take(4)
+	if r.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *reader) uint64() uint64 {
+	b := r.take(8)
+	if r.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (r *reader) bool() bool { return r.uint32() != 0 }
+
+// fixed reads fixed-length opaque data
+func (r *reader) fixed(n int) []byte {
+	b := r.take(n)
+	r.take(pad(n))
+	return b
+}
+
+// opaque reads variable-length opaque data of at most limit bytes
+func (r *reader) opaque(limit int) []byte {
+	n := r.uint32()
+	if r.err == nil && n > uint32(limit) {
+		r.err = errGarbage
+	}
+	return r.fixed(int(n))
+}
+
+func (r *reader) string(limit int) string { return string(r.opaque(limit)) }
+
+// writer encodes XDR
+type writer struct {
+	buf []byte
+}
+
+func (w *writer) uint32(v uint32) { w.buf = binary.BigEndian.AppendUint32(w.buf, v) }
+
+func (w *writer) uint64(v uint64) { w.buf = binary.BigEndian.AppendUint64(w.buf, v) }
+
+func (w *writer) bool(v bool) {
+	if v {
+		w.uint32(1)
+	} else {
+		w.uint32(0)
+	}
+}
+
+// fixed writes fixed-length opaque data
+func (w *writer) fixed(b []byte) {
+	w.buf = append(w.buf, b...)
+	w.buf = append(w.buf, make([]byte, pad(len(b)))...)
+}
+
+// opaque writes variable-length opaque data
+func (w *writer) opaque(b []byte) {
+	w.uint32(uint32(len(b)))
+	w.fixed(b)
+}
+
+func (w *writer) string(s string) { w.opaque([]byte(s)) }
+
+// pad is the number of zero bytes aligning n bytes to four
+func pad(n int) int { return (4 - n%4) % 4 }
@@ -0,0 +1,188 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/alerting"
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+)
+
+type AlertEndpoints struct {
+	alertService services.AlertService
+	logger       *slog.Logger
+}
+
+func NewAlertEndpoints(alertService services.AlertService, logger *slog.Logger) *AlertEndpoints {
+	return &AlertEndpoints{
+		alertService: alertService,
+		logger:       logger,
+	}
+}
+
+// HandleListAlerts handles GET /alerts
+func (e *AlertEndpoints) HandleListAlerts(w http.ResponseWriter, r *http.Request) {
+	alerts := e.alertService.ListAlerts(r.Context())
+	e.writeJSON(w, http.StatusOK, responses.AlertListResponse{Alerts: alerts, Total: len(alerts)})
+}
+
+// HandleListRules handles GET /alerts/rules
+func (e *AlertEndpoints) HandleListRules(w http.ResponseWriter, r *http.Request) {
+	rules := e.alertService.ListRules(r.Context())
+	e.writeJSON(w, http.StatusOK, responses.AlertRuleListResponse{Rules: rules, Total: len(rules)})
+}
+
+// HandlePutRule handles PUT /alerts/rules/{name}
+func (e *AlertEndpoints) HandlePutRule(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var req requests.AlertRuleRequest
+	if !e.decode(w, r, &req) {
+		return
+	}
+
+	rule, err := e.alertService.PutRule(r.Context(), name, &req)
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Alert rule saved", "name", name, "metric", rule.Metric, "kind", rule.Kind)
+	e.writeJSON(w, http.StatusOK, rule)
+}
+
+// HandleDeleteRule handles DELETE /alerts/rules/{name}
+func (e *AlertEndpoints) HandleDeleteRule(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := e.alertService.DeleteRule(r.Context(), name); err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Alert rule deleted", "name", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListChannels handles GET /alerts/channels
+func (e *AlertEndpoints) HandleListChannels(w http.ResponseWriter, r *http.Request) {
+	channels := e.alertService.ListChannels(r.Context())
+	e.writeJSON(w, http.StatusOK, responses.AlertChannelListResponse{Channels: channels, Total: len(channels)})
+}
+
+// HandlePutChannel handles PUT /alerts/channels/{name}
+func (e *AlertEndpoints) HandlePutChannel(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var req requests.AlertChannelRequest
+	if !e.decode(w, r, &req) {
+		return
+	}
+
+	channel, err := e.alertService.PutChannel(r.Context(), name, &req)
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Alert channel saved", "name", name, "type", channel.Type)
+	e.writeJSON(w, http.StatusOK, channel)
+}
+
+// HandleDeleteChannel handles DELETE /alerts/channels/{name}
+func (e *AlertEndpoints) HandleDeleteChannel(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := e.alertService.DeleteChannel(r.Context(), name); err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Alert channel deleted", "name", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListSilences handles GET /alerts/silences
+func (e *AlertEndpoints) HandleListSilences(w http.ResponseWriter, r *http.Request) {
+	silences := e.alertService.ListSilences(r.Context())
+	e.writeJSON(w, http.StatusOK, responses.SilenceListResponse{Silences: silences, Total: len(silences)})
+}
+
+// HandleAddSilence handles POST /alerts/silences
+func (e *AlertEndpoints) HandleAddSilence(w http.ResponseWriter, r *http.Request) {
+	var req requests.SilenceRequest
+	if !e.decode(w, r, &req) {
+		return
+	}
+
+	silence, err := e.alertService.AddSilence(r.Context(), &req)
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Silence added", "id", silence.ID, "rule", silence.Rule, "ends_at", silence.EndsAt)
+	e.writeJSON(w, http.StatusCreated, silence)
+}
+
+// HandleDeleteSilence handles DELETE /alerts/silences/{id}
+func (e *AlertEndpoints) HandleDeleteSilence(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := e.alertService.DeleteSilence(r.Context(), id); err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Silence deleted", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListMetrics handles GET /alerts/metrics
+func (e *AlertEndpoints) HandleListMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics := e.alertService.ListMetrics(r.Context())
+	e.writeJSON(w, http.StatusOK, responses.MetricListResponse{Metrics: metrics, Total: len(metrics)})
+}
+
+// HandlePushMetrics handles POST /alerts/metrics
+func (e *AlertEndpoints) HandlePushMetrics(w http.ResponseWriter, r *http.Request) {
+	var req requests.MetricsRequest
+	if !e.decode(w, r, &req) {
+		return
+	}
+
+	if err := e.alertService.PushMetrics(r.Context(), &req); err != nil {
+		e.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *AlertEndpoints) decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(v); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (e *AlertEndpoints) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, alerting.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, alerting.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		e.logger.Error("Alerting failed", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (e *AlertEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode alert response", "error", err)
+	}
+}
@@ -0,0 +1,91 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/analytics"
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+)
+
+type AnalyticsEndpoints struct {
+	analyticsService services.AnalyticsService
+	logger           *slog.Logger
+}
+
+func NewAnalyticsEndpoints(analyticsService services.AnalyticsService, logger *slog.Logger) *AnalyticsEndpoints {
+	return &AnalyticsEndpoints{
+		analyticsService: analyticsService,
+		logger:           logger,
+	}
+}
+
+// HandleGetRollups handles GET /analytics/access?dimension=&value=&from=&to=
+func (e *AnalyticsEndpoints) HandleGetRollups(w http.ResponseWriter, r *http.Request) {
+	q, err := accessQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rollups, err := e.analyticsService.GetRollups(r.Context(), q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e.writeJSON(w, responses.AccessRollupsResponse{Dimension: q.Dimension, BucketSeconds: e.analyticsService.BucketSeconds(), Rollups: rollups})
+}
+
+// HandleGetTop handles GET /analytics/access/top?dimension=&from=&to=&limit=
+func (e *AnalyticsEndpoints) HandleGetTop(w http.ResponseWriter, r *http.Request) {
+	q, err := accessQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := intParam(r.URL.Query().Get("limit"), 10)
+	if err != nil {
+		http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+		return
+	}
+	totals, err := e.analyticsService.GetTop(r.Context(), q, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e.writeJSON(w, responses.AccessTopResponse{Dimension: q.Dimension, Totals: totals})
+}
+
+// accessQuery reads a rollup query from URL parameters; the dimension
+// defaults to key
+func accessQuery(params url.Values) (analytics.Query, error) {
+	q := analytics.Query{Dimension: analytics.ByKey, Value: params.Get("value")}
+	if d := params.Get("dimension"); d != "" {
+		dim, err := analytics.ParseDimension(d)
+		if err != nil {
+			return q, err
+		}
+		q.Dimension = dim
+	}
+	for name, t := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if v := params.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s parameter, expected RFC 3339: %w", name, err)
+			}
+			*t = parsed
+		}
+	}
+	return q, nil
+}
+
+func (e *AnalyticsEndpoints) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode analytics response", "error", err)
+	}
+}
@@ -0,0 +1,150 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/backup"
+)
+
+type BackupEndpoints struct {
+	backupService services.BackupService
+	logger        *slog.Logger
+}
+
+func NewBackupEndpoints(backupService services.BackupService, logger *slog.Logger) *BackupEndpoints {
+	return &BackupEndpoints{
+		backupService: backupService,
+		logger:        logger,
+	}
+}
+
+// HandleListJobs handles GET /backups/jobs
+func (e *BackupEndpoints) HandleListJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := e.backupService.ListJobs(r.Context())
+	if err != nil {
+		e.logger.Error("Failed to list backup jobs", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, responses.BackupJobListResponse{Jobs: jobs, Total: len(jobs)})
+}
+
+// HandleRunJob handles POST /backups/jobs/{job}/run?type=full|incremental.
+// The backup runs in the background; its outcome shows in the job list.
+func (e *BackupEndpoints) HandleRunJob(w http.ResponseWriter, r *http.Request) {
+	job := r.PathValue("job")
+	typ := backup.BackupTypeIncremental
+	if value := r.URL.Query().Get("type"); value != "" {
+		typ = backup.BackupType(value)
+		if typ != backup.BackupTypeFull && typ != backup.BackupTypeIncremental {
+			http.Error(w, "Invalid type parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := e.backupService.RunBackup(r.Context(), job, typ); err != nil {
+		e.writeError(w, "Failed to start backup", job, err)
+		return
+	}
+	e.logger.Info("Backup started", "job", job, "type", typ)
+	e.writeJSON(w, http.StatusAccepted, responses.BackupRunResponse{Job: job, Type: typ, Status: "started"})
+}
+
+// HandleListSnapshots handles GET /backups/jobs/{job}/snapshots
+func (e *BackupEndpoints) HandleListSnapshots(w http.ResponseWriter, r *http.Request) {
+	job := r.PathValue("job")
+	snaps, err := e.backupService.ListSnapshots(r.Context(), job)
+	if err != nil {
+		e.writeError(w, "Failed to list snapshots", job, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, responses.BackupSnapshotListResponse{Snapshots: snaps, Total: len(snaps)})
+}
+
+// HandleGetSnapshot handles GET /backups/jobs/{job}/snapshots/{id}
+func (e *BackupEndpoints) HandleGetSnapshot(w http.ResponseWriter, r *http.Request) {
+	job := r.PathValue("job")
+	snap, err := e.backupService.GetSnapshot(r.Context(), job, r.PathValue("id"))
+	if err != nil {
+		e.writeError(w, "Failed to get snapshot", job, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, snap)
+}
+
+// HandleVerifySnapshot handles POST /backups/jobs/{job}/snapshots/{id}/verify
+func (e *BackupEndpoints) HandleVerifySnapshot(w http.ResponseWriter, r *http.Request) {
+	job := r.PathValue("job")
+	report, err := e.backupService.VerifySnapshot(r.Context(), job, r.PathValue("id"))
+	if err != nil {
+		e.writeError(w, "Failed to verify snapshot", job, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, report)
+}
+
+// HandleDeleteSnapshot handles DELETE /backups/jobs/{job}/snapshots/{id}
+func (e *BackupEndpoints) HandleDeleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	job, id := r.PathValue("job"), r.PathValue("id")
+	if err := e.backupService.DeleteSnapshot(r.Context(), job, id); err != nil {
+		e.writeError(w, "Failed to delete snapshot", job, err)
+		return
+	}
+	e.logger.Info("Backup snapshot deleted", "job", job, "snapshot", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleRestore handles POST /backups/jobs/{job}/restore
+func (e *BackupEndpoints) HandleRestore(w http.ResponseWriter, r *http.Request) {
+	job := r.PathValue("job")
+	var req requests.BackupRestoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	opts := backup.RestoreOptions{Snapshot: req.Snapshot, Prefix: req.Prefix, Overwrite: req.Overwrite}
+	if req.At != "" {
+		at, err := time.Parse(time.RFC3339, req.At)
+		if err != nil {
+			http.Error(w, "Invalid at timestamp, expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+		opts.At = at
+	}
+
+	report, err := e.backupService.Restore(r.Context(), job, opts)
+	if err != nil {
+		e.writeError(w, "Restore failed", job, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, report)
+}
+
+// writeError maps backup errors to status codes
+func (e *BackupEndpoints) writeError(w http.ResponseWriter, msg, job string, err error) {
+	switch {
+	case errors.Is(err, backup.ErrJobNotFound), errors.Is(err, backup.ErrSnapshotNotFound), errors.Is(err, backup.ErrNoSnapshot):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, backup.ErrJobRunning), errors.Is(err, backup.ErrSnapshotInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		e.logger.Error(msg, "job", job, "error", err)
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
+}
+
+func (e *BackupEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode response", "error", err)
+	}
+}
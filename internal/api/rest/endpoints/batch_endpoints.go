@@ -0,0 +1,103 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/batch"
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"github.com/Skpow1234/Peervault/internal/hooks"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+	"github.com/Skpow1234/Peervault/internal/policy"
+	"github.com/Skpow1234/Peervault/internal/retention"
+	"github.com/Skpow1234/Peervault/internal/scan"
+)
+
+type BatchEndpoints struct {
+	batchService services.BatchService
+	logger       *slog.Logger
+}
+
+func NewBatchEndpoints(batchService services.BatchService, logger *slog.Logger) *BatchEndpoints {
+	return &BatchEndpoints{
+		batchService: batchService,
+		logger:       logger,
+	}
+}
+
+// HandleBatch handles POST /batch
+func (e *BatchEndpoints) HandleBatch(w http.ResponseWriter, r *http.Request) {
+	var request requests.BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := batch.Validate(request.Operations); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	outcomes := e.batchService.Execute(r.Context(), request.Operations, request.Parallelism)
+	response := responses.BatchResponse{Results: make([]responses.BatchResultResponse, len(outcomes))}
+	for i, outcome := range outcomes {
+		op := request.Operations[i]
+		result := responses.BatchResultResponse{Index: i, Op: op.Op, Key: op.Target(), Status: batchStatus(op, outcome.Err)}
+		if outcome.Err != nil {
+			e.logger.Warn("Batch operation failed", "op", op.Op, "key", result.Key, "error", outcome.Err)
+			result.Error = outcome.Err.Error()
+			response.Failed++
+		} else {
+			if file, ok := outcome.Value.(*types.File); ok && file != nil {
+				result.File = types.FileToResponse(file)
+			}
+			response.Succeeded++
+		}
+		response.Results[i] = result
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// batchStatus returns the status an operation would have had on its own
+// endpoint
+func batchStatus(op batch.Operation, err error) int {
+	switch {
+	case err == nil && op.Op == batch.OpDelete:
+		return http.StatusNoContent
+	case err == nil && op.Op == batch.OpTag:
+		return http.StatusOK
+	case err == nil:
+		return http.StatusCreated
+	case errors.Is(err, metadata.ErrNotFound), errors.Is(err, directory.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, directory.ErrExists):
+		return http.StatusConflict
+	case errors.Is(err, batch.ErrInvalid), errors.Is(err, directory.ErrInvalidPath):
+		return http.StatusBadRequest
+	case errors.Is(err, batch.ErrFetch):
+		return http.StatusBadGateway
+	case errors.Is(err, retention.ErrLocked):
+		return http.StatusLocked
+	case errors.Is(err, policy.ErrDenied), errors.Is(err, hooks.ErrRejected):
+		return http.StatusForbidden
+	case errors.Is(err, fileserver.ErrStorageFull):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, scan.ErrRejected), errors.Is(err, scan.ErrQuarantined):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, scan.ErrScannerFailed), errors.Is(err, metadata.ErrNotPrimary), errors.Is(err, metadata.ErrFenced):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
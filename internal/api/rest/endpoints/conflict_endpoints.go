@@ -0,0 +1,111 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/retention"
+)
+
+type ConflictEndpoints struct {
+	conflictService services.ConflictService
+	logger          *slog.Logger
+}
+
+func NewConflictEndpoints(conflictService services.ConflictService, logger *slog.Logger) *ConflictEndpoints {
+	return &ConflictEndpoints{
+		conflictService: conflictService,
+		logger:          logger,
+	}
+}
+
+// HandleListConflicts handles GET /conflicts
+func (e *ConflictEndpoints) HandleListConflicts(w http.ResponseWriter, r *http.Request) {
+	conflicts := e.conflictService.ListConflicts(r.Context())
+	if conflicts == nil {
+		conflicts = []fileserver.FileVersions{}
+	}
+	e.writeJSON(w, http.StatusOK, responses.ConflictListResponse{Conflicts: conflicts, Total: len(conflicts)})
+}
+
+// HandleGetVersions handles GET /files/{key}/versions
+func (e *ConflictEndpoints) HandleGetVersions(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	versions, err := e.conflictService.GetVersions(r.Context(), key)
+	if err != nil {
+		e.writeError(w, "Failed to get versions", key, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, versions)
+}
+
+// HandleGetVersionContent handles GET /files/{key}/versions/{id}/content
+func (e *ConflictEndpoints) HandleGetVersionContent(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	data, err := e.conflictService.GetVersionContent(r.Context(), key, r.PathValue("id"))
+	if err != nil {
+		e.writeError(w, "Failed to read version", key, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := w.Write(data); err != nil {
+		e.logger.Error("Failed to write version content", "key", key, "error", err)
+	}
+}
+
+// HandlePickVersion handles POST /files/{key}/versions/{id}/pick
+func (e *ConflictEndpoints) HandlePickVersion(w http.ResponseWriter, r *http.Request) {
+	key, id := r.PathValue("key"), r.PathValue("id")
+	version, err := e.conflictService.PickVersion(r.Context(), key, id)
+	if err != nil {
+		e.writeError(w, "Failed to resolve conflict", key, err)
+		return
+	}
+
+	e.logger.Info("Conflict resolved", "key", key, "picked", id, "version", version.ID)
+	e.writeJSON(w, http.StatusOK, version)
+}
+
+// HandleMergeVersions handles POST /files/{key}/versions/merge
+func (e *ConflictEndpoints) HandleMergeVersions(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	version, err := e.conflictService.MergeVersions(r.Context(), key, r.Body)
+	if err != nil {
+		e.writeError(w, "Failed to resolve conflict", key, err)
+		return
+	}
+
+	e.logger.Info("Conflict resolved", "key", key, "merged", true, "version", version.ID)
+	e.writeJSON(w, http.StatusOK, version)
+}
+
+// writeError maps the errors of conflict resolution to statuses: files and
+// versions this node does not hold are not found, and resolving a file
+// without conflicts or a locked file conflicts
+func (e *ConflictEndpoints) writeError(w http.ResponseWriter, msg, key string, err error) {
+	switch {
+	case errors.Is(err, fileserver.ErrFileNotHeld):
+		http.Error(w, "File not found", http.StatusNotFound)
+	case errors.Is(err, fileserver.ErrVersionNotFound):
+		http.Error(w, "Version not found", http.StatusNotFound)
+	case errors.Is(err, fileserver.ErrNoConflict), errors.Is(err, retention.ErrLocked):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		e.logger.Error(msg, "key", key, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (e *ConflictEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode conflict response", "error", err)
+	}
+}
@@ -0,0 +1,72 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+)
+
+type DecommissionEndpoints struct {
+	decommissionService services.DecommissionService
+	logger              *slog.Logger
+}
+
+func NewDecommissionEndpoints(decommissionService services.DecommissionService, logger *slog.Logger) *DecommissionEndpoints {
+	return &DecommissionEndpoints{
+		decommissionService: decommissionService,
+		logger:              logger,
+	}
+}
+
+// HandleGetStatus handles GET /decommission
+func (e *DecommissionEndpoints) HandleGetStatus(w http.ResponseWriter, r *http.Request) {
+	e.writeJSON(w, http.StatusOK, e.decommissionService.Status(r.Context()))
+}
+
+// HandleStart handles POST /decommission
+func (e *DecommissionEndpoints) HandleStart(w http.ResponseWriter, r *http.Request) {
+	status, err := e.decommissionService.Start(r.Context())
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Node decommission started")
+	e.writeJSON(w, http.StatusAccepted, status)
+}
+
+// HandleAbort handles DELETE /decommission
+func (e *DecommissionEndpoints) HandleAbort(w http.ResponseWriter, r *http.Request) {
+	status, err := e.decommissionService.Abort(r.Context())
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Node decommission aborted", "evacuated", status.Evacuated, "files", status.Files)
+	e.writeJSON(w, http.StatusOK, status)
+}
+
+func (e *DecommissionEndpoints) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fileserver.ErrDecommissioning),
+		errors.Is(err, fileserver.ErrNotDecommissioning),
+		errors.Is(err, fileserver.ErrNoRemainingNodes):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		e.logger.Error("Decommission failed", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (e *DecommissionEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode decommission response", "error", err)
+	}
+}
@@ -0,0 +1,197 @@
+package endpoints
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/clientip"
+	"github.com/Skpow1234/Peervault/internal/devices"
+)
+
+// Content types of EST over HTTP (RFC 7030)
+const (
+	certsOnlyContentType = "application/pkcs7-mime; smime-type=certs-only"
+	crlContentType       = "application/pkix-crl"
+)
+
+// maxCSRSize bounds the certificate requests read
+const maxCSRSize = 64 << 10
+
+type DeviceEndpoints struct {
+	deviceService services.DeviceService
+	logger        *slog.Logger
+}
+
+func NewDeviceEndpoints(deviceService services.DeviceService, logger *slog.Logger) *DeviceEndpoints {
+	return &DeviceEndpoints{
+		deviceService: deviceService,
+		logger:        logger,
+	}
+}
+
+// HandleListDevices handles GET /devices
+func (e *DeviceEndpoints) HandleListDevices(w http.ResponseWriter, r *http.Request) {
+	list := e.deviceService.ListDevices(r.Context())
+	e.writeJSON(w, http.StatusOK, responses.DeviceListResponse{Devices: list, Total: len(list)})
+}
+
+// HandleGetDevice handles GET /devices/{id}
+func (e *DeviceEndpoints) HandleGetDevice(w http.ResponseWriter, r *http.Request) {
+	device, err := e.deviceService.GetDevice(r.Context(), r.PathValue("id"))
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, device)
+}
+
+// HandleRegisterDevice handles POST /devices
+func (e *DeviceEndpoints) HandleRegisterDevice(w http.ResponseWriter, r *http.Request) {
+	var req requests.DeviceRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	device, secret, err := e.deviceService.RegisterDevice(r.Context(), &req, "api")
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Device registered", "id", device.ID)
+	e.writeJSON(w, http.StatusCreated, responses.DeviceSecretResponse{Device: device, Secret: secret})
+}
+
+// HandleResetSecret handles POST /devices/{id}/secret
+func (e *DeviceEndpoints) HandleResetSecret(w http.ResponseWriter, r *http.Request) {
+	device, secret, err := e.deviceService.ResetSecret(r.Context(), r.PathValue("id"), "api")
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Device secret reset", "id", device.ID)
+	e.writeJSON(w, http.StatusOK, responses.DeviceSecretResponse{Device: device, Secret: secret})
+}
+
+// HandleRevokeDevice handles DELETE /devices/{id}
+func (e *DeviceEndpoints) HandleRevokeDevice(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := e.deviceService.RevokeDevice(r.Context(), id, "api"); err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Device revoked", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleRevokeCertificate handles DELETE /devices/{id}/certificates/{serial}
+func (e *DeviceEndpoints) HandleRevokeCertificate(w http.ResponseWriter, r *http.Request) {
+	id, serial := r.PathValue("id"), r.PathValue("serial")
+	if err := e.deviceService.RevokeCertificate(r.Context(), id, serial, "api"); err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Device certificate revoked", "id", id, "serial", serial)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleCACerts handles GET /.well-known/est/cacerts
+func (e *DeviceEndpoints) HandleCACerts(w http.ResponseWriter, r *http.Request) {
+	certs, err := e.deviceService.CACertificates(r.Context())
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeBase64(w, certsOnlyContentType, certs)
+}
+
+// HandleCRL handles GET /.well-known/est/crl
+func (e *DeviceEndpoints) HandleCRL(w http.ResponseWriter, r *http.Request) {
+	crl, err := e.deviceService.CRL(r.Context())
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", crlContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(crl)
+}
+
+// HandleSimpleEnroll handles POST /.well-known/est/simpleenroll
+func (e *DeviceEndpoints) HandleSimpleEnroll(w http.ResponseWriter, r *http.Request) {
+	e.enroll(w, r, false)
+}
+
+// HandleSimpleReenroll handles POST /.well-known/est/simplereenroll
+func (e *DeviceEndpoints) HandleSimpleReenroll(w http.ResponseWriter, r *http.Request) {
+	e.enroll(w, r, true)
+}
+
+// enroll issues a certificate for the PKCS#10 request in the body, base64
+// encoded as RFC 7030 asks or raw DER
+func (e *DeviceEndpoints) enroll(w http.ResponseWriter, r *http.Request, renew bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCSRSize))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	csr := body
+	if decoded, err := base64.StdEncoding.DecodeString(string(bytes.Join(bytes.Fields(body), nil))); err == nil {
+		csr = decoded
+	}
+	// Devices sending Basic auth prove their secret with its password,
+	// the others with the challengePassword of the request
+	_, secret, _ := r.BasicAuth()
+
+	certs, err := e.deviceService.Enroll(r.Context(), csr, secret, renew, clientip.FromRequest(r))
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeBase64(w, certsOnlyContentType, certs)
+}
+
+func (e *DeviceEndpoints) writeBase64(w http.ResponseWriter, contentType string, data []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, base64.StdEncoding.EncodeToString(data))
+}
+
+func (e *DeviceEndpoints) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, devices.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, devices.ErrExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, devices.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, devices.ErrDenied):
+		// Say no more than that to unauthenticated callers
+		w.Header().Set("WWW-Authenticate", `Basic realm="PeerVault EST"`)
+		http.Error(w, "Enrollment denied", http.StatusUnauthorized)
+	default:
+		e.logger.Error("Device request failed", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (e *DeviceEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode device response", "error", err)
+	}
+}
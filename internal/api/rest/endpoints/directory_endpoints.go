@@ -0,0 +1,115 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"github.com/Skpow1234/Peervault/internal/retention"
+)
+
+type DirectoryEndpoints struct {
+	directoryService services.DirectoryService
+	logger           *slog.Logger
+}
+
+func NewDirectoryEndpoints(directoryService services.DirectoryService, logger *slog.Logger) *DirectoryEndpoints {
+	return &DirectoryEndpoints{
+		directoryService: directoryService,
+		logger:           logger,
+	}
+}
+
+// HandleListDirectory handles GET /directories/{path...}?recursive=
+func (e *DirectoryEndpoints) HandleListDirectory(w http.ResponseWriter, r *http.Request) {
+	path := r.PathValue("path")
+	listing, err := e.directoryService.ListDirectory(r.Context(), path, r.URL.Query().Get("recursive") == "true")
+	if err != nil {
+		e.writeError(w, "Failed to list directory", path, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, listing)
+}
+
+// HandleMakeDirectory handles PUT /directories/{path...}
+func (e *DirectoryEndpoints) HandleMakeDirectory(w http.ResponseWriter, r *http.Request) {
+	path := r.PathValue("path")
+	dir, err := e.directoryService.MakeDirectory(r.Context(), path)
+	if err != nil {
+		e.writeError(w, "Failed to create directory", path, err)
+		return
+	}
+	e.writeJSON(w, http.StatusCreated, dir)
+}
+
+// HandleDeleteDirectory handles DELETE /directories/{path...}?recursive=
+func (e *DirectoryEndpoints) HandleDeleteDirectory(w http.ResponseWriter, r *http.Request) {
+	path := r.PathValue("path")
+	report, err := e.directoryService.DeleteDirectory(r.Context(), path, r.URL.Query().Get("recursive") == "true")
+	if err != nil {
+		e.writeError(w, "Failed to delete directory", path, err)
+		return
+	}
+	e.logger.Info("Directory deleted", "path", path, "files", report.Files, "failed", report.Failed)
+	e.writeJSON(w, http.StatusOK, report)
+}
+
+// HandleCopy handles POST /directories/copy
+func (e *DirectoryEndpoints) HandleCopy(w http.ResponseWriter, r *http.Request) {
+	var req requests.DirectoryTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.From == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	report, err := e.directoryService.Copy(r.Context(), req.From, req.To, req.Overwrite)
+	if err != nil {
+		e.writeError(w, "Copy failed", req.From, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, report)
+}
+
+// HandleMove handles POST /directories/move
+func (e *DirectoryEndpoints) HandleMove(w http.ResponseWriter, r *http.Request) {
+	var req requests.DirectoryTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.From == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	report, err := e.directoryService.Move(r.Context(), req.From, req.To, req.Overwrite)
+	if err != nil {
+		e.writeError(w, "Move failed", req.From, err)
+		return
+	}
+	e.logger.Info("Moved", "from", report.From, "to", report.To, "files", report.Files, "failed", report.Failed)
+	e.writeJSON(w, http.StatusOK, report)
+}
+
+// writeError maps directory errors to status codes
+func (e *DirectoryEndpoints) writeError(w http.ResponseWriter, msg, path string, err error) {
+	switch {
+	case errors.Is(err, directory.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, directory.ErrExists), errors.Is(err, directory.ErrNotEmpty):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, directory.ErrInvalidPath):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, retention.ErrLocked):
+		http.Error(w, err.Error(), http.StatusLocked)
+	default:
+		e.logger.Error(msg, "path", path, "error", err)
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
+}
+
+func (e *DirectoryEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode response", "error", err)
+	}
+}
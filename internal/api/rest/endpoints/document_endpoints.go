@@ -0,0 +1,80 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/crdt"
+)
+
+type DocumentEndpoints struct {
+	documentService services.DocumentService
+	logger          *slog.Logger
+}
+
+func NewDocumentEndpoints(documentService services.DocumentService, logger *slog.Logger) *DocumentEndpoints {
+	return &DocumentEndpoints{
+		documentService: documentService,
+		logger:          logger,
+	}
+}
+
+// HandleListDocuments handles GET /documents
+func (e *DocumentEndpoints) HandleListDocuments(w http.ResponseWriter, r *http.Request) {
+	names := e.documentService.ListDocuments(r.Context())
+	e.writeJSON(w, http.StatusOK, responses.DocumentListResponse{Documents: names, Total: len(names)})
+}
+
+// HandleGetDocument handles GET /documents/{name}
+func (e *DocumentEndpoints) HandleGetDocument(w http.ResponseWriter, r *http.Request) {
+	view, err := e.documentService.GetDocument(r.Context(), r.PathValue("name"))
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, view)
+}
+
+// HandleUpdateDocument handles PATCH /documents/{name}
+func (e *DocumentEndpoints) HandleUpdateDocument(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var req requests.DocumentUpdateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, crdt.MaxDocumentSize)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	view, err := e.documentService.UpdateDocument(r.Context(), name, &req)
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Document updated", "name", name)
+	e.writeJSON(w, http.StatusOK, view)
+}
+
+func (e *DocumentEndpoints) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, crdt.ErrNotFound):
+		http.Error(w, "Document not found", http.StatusNotFound)
+	case errors.Is(err, crdt.ErrTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func (e *DocumentEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode document response", "error", err)
+	}
+}
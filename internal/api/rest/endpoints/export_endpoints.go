@@ -0,0 +1,79 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/export"
+)
+
+type ExportEndpoints struct {
+	exportService services.ExportService
+	logger        *slog.Logger
+}
+
+func NewExportEndpoints(exportService services.ExportService, logger *slog.Logger) *ExportEndpoints {
+	return &ExportEndpoints{
+		exportService: exportService,
+		logger:        logger,
+	}
+}
+
+// HandleListExports handles GET /exports
+func (e *ExportEndpoints) HandleListExports(w http.ResponseWriter, r *http.Request) {
+	infos := e.exportService.ListExports(r.Context())
+	resp := responses.ExportListResponse{Exports: make([]responses.ExportResponse, len(infos)), Total: len(infos)}
+	for i, info := range infos {
+		item := responses.ExportResponse{
+			Name:        info.Name,
+			Dataset:     string(info.Dataset),
+			Format:      string(info.Format),
+			Schedule:    info.Schedule,
+			Dimension:   string(info.Dimension),
+			Prefix:      info.Prefix,
+			Destination: info.Destination,
+			LastRun:     info.LastRun,
+			LastKey:     info.LastKey,
+			LastRows:    info.LastRows,
+			LastError:   info.LastError,
+		}
+		if info.Dataset == export.Accesses || info.Dataset == export.Rollups {
+			item.Range = info.Range.String()
+		}
+		resp.Exports[i] = item
+	}
+	e.writeJSON(w, http.StatusOK, resp)
+}
+
+// HandleRunExport handles POST /exports/{name}/run
+func (e *ExportEndpoints) HandleRunExport(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	run, err := e.exportService.RunExport(r.Context(), name)
+	if err != nil {
+		switch {
+		case errors.Is(err, export.ErrNotFound):
+			http.Error(w, "Export not found", http.StatusNotFound)
+		case errors.Is(err, export.ErrUnavailable):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			e.logger.Error("Export failed", "name", name, "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	e.logger.Info("Export run", "name", name, "key", run.Key, "rows", run.Rows)
+	e.writeJSON(w, http.StatusOK, run)
+}
+
+func (e *ExportEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode export response", "error", err)
+	}
+}
@@ -1,14 +1,30 @@
 package endpoints
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/Skpow1234/Peervault/internal/api/rest/services"
 	"github.com/Skpow1234/Peervault/internal/api/rest/types"
 	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"github.com/Skpow1234/Peervault/internal/etag"
+	"github.com/Skpow1234/Peervault/internal/hooks"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+	"github.com/Skpow1234/Peervault/internal/policy"
+	"github.com/Skpow1234/Peervault/internal/retention"
+	"github.com/Skpow1234/Peervault/internal/scan"
+	"github.com/Skpow1234/Peervault/pkg/merkle"
 )
 
 type FileEndpoints struct {
@@ -24,7 +40,16 @@ func NewFileEndpoints(fileService services.FileService, logger *slog.Logger) *Fi
 }
 
 func (e *FileEndpoints) HandleListFiles(w http.ResponseWriter, r *http.Request) {
-	files, err := e.fileService.ListFiles(r.Context())
+	// Filter by ?tag=a&tag=b and ?meta.<key>=<value> when given
+	tags, attrs := parseAttributeFilters(r)
+
+	var files []types.File
+	var err error
+	if len(tags) > 0 || len(attrs) > 0 {
+		files, err = e.fileService.SearchFiles(r.Context(), tags, attrs)
+	} else {
+		files, err = e.fileService.ListFiles(r.Context())
+	}
 	if err != nil {
 		e.logger.Error("Failed to list files", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -52,6 +77,18 @@ func (e *FileEndpoints) HandleGetFile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
+	if file.Hash != "" {
+		w.Header().Set("ETag", etag.Quote(file.Hash))
+		notModified, err := etag.FromRequest(r).CheckRead(file.Hash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		if notModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
 
 	response := types.FileToResponse(file)
 	w.Header().Set("Content-Type", "application/json")
@@ -61,6 +98,97 @@ func (e *FileEndpoints) HandleGetFile(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// HandleDownloadFile handles GET /files/{key}/content
+func (e *FileEndpoints) HandleDownloadFile(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	file, data, err := e.fileService.DownloadFile(r.Context(), key)
+	if err != nil {
+		e.logger.Error("Failed to download file", "key", key, "error", err)
+		if errors.Is(err, hooks.ErrRejected) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if file.Hash != "" {
+		// ServeContent answers If-Match, If-None-Match and If-Range with it
+		w.Header().Set("ETag", etag.Quote(file.Hash))
+	}
+	http.ServeContent(w, r, file.Name, file.UpdatedAt, bytes.NewReader(data))
+}
+
+// HandleGetFileManifest handles GET /files/{key}/manifest
+func (e *FileEndpoints) HandleGetFileManifest(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	chunkSize := int64(merkle.DefaultChunkSize)
+	if value := r.URL.Query().Get("chunk_size"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || !merkle.ValidChunkSize(n) {
+			http.Error(w, "Invalid chunk_size", http.StatusBadRequest)
+			return
+		}
+		chunkSize = n
+	}
+
+	manifest, err := e.fileService.GetFileManifest(r.Context(), key, chunkSize)
+	if err != nil {
+		e.logger.Error("Failed to get file manifest", "key", key, "error", err)
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(manifest); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleGetUsage handles GET /files/usage?prefix=&depth=
+func (e *FileEndpoints) HandleGetUsage(w http.ResponseWriter, r *http.Request) {
+	depth, err := intParam(r.URL.Query().Get("depth"), 0)
+	if err != nil {
+		http.Error(w, "Invalid depth parameter", http.StatusBadRequest)
+		return
+	}
+	usage, err := e.fileService.GetUsage(r.Context(), r.URL.Query().Get("prefix"), depth)
+	if err != nil {
+		e.logger.Error("Failed to get usage", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(responses.FileUsageResponse{Prefixes: usage}); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleGetTenantUsage handles GET /files/usage/tenants
+func (e *FileEndpoints) HandleGetTenantUsage(w http.ResponseWriter, r *http.Request) {
+	usage, err := e.fileService.GetTenantUsage(r.Context())
+	if err != nil {
+		e.logger.Error("Failed to get tenant usage", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(responses.TenantUsageResponse{Tenants: usage}); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
 func (e *FileEndpoints) HandleUploadFile(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseMultipartForm(32 << 20); err != nil {
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
@@ -94,15 +222,44 @@ func (e *FileEndpoints) HandleUploadFile(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	uploadedFile, err := e.fileService.UploadFile(r.Context(), header.Filename, data, header.Header.Get("Content-Type"), metadata)
+	tags := splitTags(r.FormValue("tags"))
+
+	// A path places the file in a folder; one ending in "/" keeps its name.
+	// If-Match and If-None-Match check the file it replaces; without a path
+	// there is none.
+	cond := etag.FromRequest(r)
+	var uploadedFile *types.File
+	if path := r.FormValue("path"); path != "" {
+		if strings.HasSuffix(path, "/") {
+			path += header.Filename
+		}
+		if cond.IsZero() {
+			uploadedFile, err = e.fileService.UploadFileAt(r.Context(), path, header.Filename, data, header.Header.Get("Content-Type"), metadata, tags)
+		} else {
+			uploadedFile, err = e.fileService.UploadFileIf(r.Context(), path, header.Filename, data, header.Header.Get("Content-Type"), metadata, tags, cond)
+		}
+	} else if err = cond.Check("", false); err == nil {
+		uploadedFile, err = e.fileService.UploadFile(r.Context(), header.Filename, data, header.Header.Get("Content-Type"), metadata, tags)
+	}
 	if err != nil {
 		e.logger.Error("Failed to upload file", "error", err)
-		http.Error(w, "Failed to upload file", http.StatusInternalServerError)
+		switch {
+		case errors.Is(err, directory.ErrInvalidPath):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, retention.ErrLocked):
+			http.Error(w, err.Error(), http.StatusLocked)
+			return
+		}
+		if !writeStoreError(w, err) {
+			http.Error(w, "Failed to upload file", http.StatusInternalServerError)
+		}
 		return
 	}
 
 	response := types.FileToResponse(uploadedFile)
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag.Quote(uploadedFile.Hash))
 	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
@@ -110,6 +267,28 @@ func (e *FileEndpoints) HandleUploadFile(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// writeStoreError answers uploads the node refused: 403 for files the
+// policy denied or a hook rejected, 412 for writes whose If-Match or If-None-Match did not
+// hold, 422 for files the content scanner rejected or quarantined, 503 when
+// the scanner could not check one and 507 when the node's storage is full
+func writeStoreError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, etag.ErrPrecondition):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	case errors.Is(err, policy.ErrDenied), errors.Is(err, hooks.ErrRejected):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, fileserver.ErrStorageFull):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+	case errors.Is(err, scan.ErrRejected), errors.Is(err, scan.ErrQuarantined):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, scan.ErrScannerFailed):
+		http.Error(w, "Content scanner unavailable", http.StatusServiceUnavailable)
+	default:
+		return false
+	}
+	return true
+}
+
 func (e *FileEndpoints) HandleDeleteFile(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
 	if key == "" {
@@ -117,9 +296,25 @@ func (e *FileEndpoints) HandleDeleteFile(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err := e.fileService.DeleteFile(r.Context(), key)
+	var err error
+	if cond := etag.FromRequest(r); cond.IsZero() {
+		err = e.fileService.DeleteFile(r.Context(), key)
+	} else {
+		err = e.fileService.DeleteFileIf(r.Context(), key, cond)
+	}
 	if err != nil {
 		e.logger.Error("Failed to delete file", "key", key, "error", err)
+		switch {
+		case errors.Is(err, retention.ErrLocked):
+			http.Error(w, err.Error(), http.StatusLocked)
+			return
+		case errors.Is(err, etag.ErrPrecondition):
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		case errors.Is(err, hooks.ErrRejected):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 		http.Error(w, "Failed to delete file", http.StatusInternalServerError)
 		return
 	}
@@ -127,6 +322,93 @@ func (e *FileEndpoints) HandleDeleteFile(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// HandleCopyObject handles POST /files/copy
+func (e *FileEndpoints) HandleCopyObject(w http.ResponseWriter, r *http.Request) {
+	e.handleTransfer(w, r, e.fileService.CopyObject)
+}
+
+// HandleMoveObject handles POST /files/move
+func (e *FileEndpoints) HandleMoveObject(w http.ResponseWriter, r *http.Request) {
+	e.handleTransfer(w, r, e.fileService.MoveObject)
+}
+
+func (e *FileEndpoints) handleTransfer(w http.ResponseWriter, r *http.Request, transfer func(context.Context, *requests.FileCopyRequest) (*types.File, error)) {
+	var request requests.FileCopyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.From == "" || request.To == "" {
+		http.Error(w, "from and to are required", http.StatusBadRequest)
+		return
+	}
+
+	file, err := transfer(r.Context(), &request)
+	if err != nil {
+		e.logger.Error("Failed to copy or move file", "from", request.From, "to", request.To, "error", err)
+		switch {
+		case errors.Is(err, directory.ErrNotFound):
+			http.Error(w, "File not found", http.StatusNotFound)
+		case errors.Is(err, directory.ErrExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, directory.ErrInvalidPath):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, retention.ErrLocked):
+			http.Error(w, err.Error(), http.StatusLocked)
+		default:
+			if !writeStoreError(w, err) {
+				http.Error(w, "Failed to copy or move file", http.StatusInternalServerError)
+			}
+		}
+		return
+	}
+
+	response := types.FileToResponse(file)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleComposeObject handles POST /files/compose
+func (e *FileEndpoints) HandleComposeObject(w http.ResponseWriter, r *http.Request) {
+	var request requests.FileComposeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.To == "" {
+		http.Error(w, "to and sources are required", http.StatusBadRequest)
+		return
+	}
+	if len(request.Sources) == 0 || len(request.Sources) > requests.MaxComposeSources {
+		http.Error(w, fmt.Sprintf("compose takes 1 to %d sources", requests.MaxComposeSources), http.StatusBadRequest)
+		return
+	}
+
+	file, err := e.fileService.ComposeObject(r.Context(), &request)
+	if err != nil {
+		e.logger.Error("Failed to compose file", "to", request.To, "error", err)
+		switch {
+		case errors.Is(err, directory.ErrNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, directory.ErrExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, directory.ErrInvalidPath):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, retention.ErrLocked):
+			http.Error(w, err.Error(), http.StatusLocked)
+		default:
+			if !writeStoreError(w, err) {
+				http.Error(w, "Failed to compose file", http.StatusInternalServerError)
+			}
+		}
+		return
+	}
+
+	response := types.FileToResponse(file)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
 func (e *FileEndpoints) HandleUpdateFileMetadata(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
 	if key == "" {
@@ -154,3 +436,71 @@ func (e *FileEndpoints) HandleUpdateFileMetadata(w http.ResponseWriter, r *http.
 		return
 	}
 }
+
+// HandlePatchFileMetadata handles PATCH /files/{key}/metadata
+func (e *FileEndpoints) HandlePatchFileMetadata(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if key == "" {
+		http.Error(w, "Missing key parameter", http.StatusBadRequest)
+		return
+	}
+
+	var request requests.FileMetadataPatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	file, err := e.fileService.PatchFileMetadata(r.Context(), key, &request)
+	if err != nil {
+		e.logger.Error("Failed to patch file metadata", "key", key, "error", err)
+		switch {
+		case errors.Is(err, metadata.ErrNotFound):
+			http.Error(w, "File not found", http.StatusNotFound)
+		case errors.Is(err, metadata.ErrNotPrimary), errors.Is(err, metadata.ErrFenced):
+			http.Error(w, "Metadata is read-only on this node", http.StatusServiceUnavailable)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	response := types.FileToResponse(file)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// parseAttributeFilters extracts tag and metadata filters from the query string
+func parseAttributeFilters(r *http.Request) ([]string, map[string]string) {
+	query := r.URL.Query()
+
+	var tags []string
+	for _, value := range query["tag"] {
+		tags = append(tags, splitTags(value)...)
+	}
+
+	var attrs map[string]string
+	for name, values := range query {
+		if key, ok := strings.CutPrefix(name, "meta."); ok && key != "" && len(values) > 0 {
+			if attrs == nil {
+				attrs = make(map[string]string)
+			}
+			attrs[key] = values[0]
+		}
+	}
+	return tags, attrs
+}
+
+// splitTags parses a comma-separated tag list
+func splitTags(value string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
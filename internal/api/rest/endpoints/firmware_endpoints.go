@@ -0,0 +1,260 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/firmware"
+)
+
+// Headers of image downloads carrying what devices verify images with
+const (
+	FirmwareSignatureHeader = "X-Firmware-Signature"
+	FirmwareKeyIDHeader     = "X-Firmware-Key-Id"
+	FirmwareSHA256Header    = "X-Firmware-Sha256"
+)
+
+type FirmwareEndpoints struct {
+	firmwareService services.FirmwareService
+	logger          *slog.Logger
+}
+
+func NewFirmwareEndpoints(firmwareService services.FirmwareService, logger *slog.Logger) *FirmwareEndpoints {
+	return &FirmwareEndpoints{
+		firmwareService: firmwareService,
+		logger:          logger,
+	}
+}
+
+// HandleListImages handles GET /firmware/images
+func (e *FirmwareEndpoints) HandleListImages(w http.ResponseWriter, r *http.Request) {
+	images := e.firmwareService.ListImages(r.Context())
+	e.writeJSON(w, http.StatusOK, responses.FirmwareImageListResponse{Images: images, Total: len(images)})
+}
+
+// HandleUploadImage handles POST /firmware/images. The body is the image;
+// its name, version and comment are query parameters.
+func (e *FirmwareEndpoints) HandleUploadImage(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, firmware.MaxImageSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Images are limited to 64 MiB", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		}
+		return
+	}
+
+	query := r.URL.Query()
+	img, err := e.firmwareService.UploadImage(r.Context(), query.Get("name"), query.Get("version"), data, query.Get("comment"), "api")
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Firmware image uploaded", "id", img.ID, "name", img.Name, "version", img.Version)
+	e.writeJSON(w, http.StatusCreated, img)
+}
+
+// HandleGetImage handles GET /firmware/images/{id}
+func (e *FirmwareEndpoints) HandleGetImage(w http.ResponseWriter, r *http.Request) {
+	img, err := e.firmwareService.GetImage(r.Context(), r.PathValue("id"))
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, img)
+}
+
+// HandleDeleteImage handles DELETE /firmware/images/{id}
+func (e *FirmwareEndpoints) HandleDeleteImage(w http.ResponseWriter, r *http.Request) {
+	if err := e.firmwareService.DeleteImage(r.Context(), r.PathValue("id"), "api"); err != nil {
+		e.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleImageContent handles GET /firmware/images/{id}/content, sending
+// the signature of the image along
+func (e *FirmwareEndpoints) HandleImageContent(w http.ResponseWriter, r *http.Request) {
+	img, data, err := e.firmwareService.ImageContent(r.Context(), r.PathValue("id"))
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("ETag", `"`+img.SHA256+`"`)
+	w.Header().Set(FirmwareSHA256Header, img.SHA256)
+	w.Header().Set(FirmwareSignatureHeader, img.Signature)
+	w.Header().Set(FirmwareKeyIDHeader, img.KeyID)
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(data)
+	}
+}
+
+// HandleSigningKey handles GET /firmware/key
+func (e *FirmwareEndpoints) HandleSigningKey(w http.ResponseWriter, r *http.Request) {
+	e.writeJSON(w, http.StatusOK, e.firmwareService.SigningKey(r.Context()))
+}
+
+// HandleListRollouts handles GET /firmware/rollouts
+func (e *FirmwareEndpoints) HandleListRollouts(w http.ResponseWriter, r *http.Request) {
+	rollouts := e.firmwareService.ListRollouts(r.Context())
+	e.writeJSON(w, http.StatusOK, responses.FirmwareRolloutListResponse{Rollouts: rollouts, Total: len(rollouts)})
+}
+
+// HandleCreateRollout handles POST /firmware/rollouts
+func (e *FirmwareEndpoints) HandleCreateRollout(w http.ResponseWriter, r *http.Request) {
+	var req requests.FirmwareRolloutRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rollout, err := e.firmwareService.CreateRollout(r.Context(), firmware.RolloutRequest{
+		ImageID:          req.ImageID,
+		Rings:            req.Rings,
+		FailureThreshold: req.FailureThreshold,
+		Comment:          req.Comment,
+	}, "api")
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Firmware rollout created", "id", rollout.ID, "image", rollout.ImageID)
+	e.writeJSON(w, http.StatusCreated, rollout)
+}
+
+// HandleGetRollout handles GET /firmware/rollouts/{id}
+func (e *FirmwareEndpoints) HandleGetRollout(w http.ResponseWriter, r *http.Request) {
+	rollout, devices, err := e.firmwareService.GetRollout(r.Context(), r.PathValue("id"))
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, responses.FirmwareRolloutResponse{Rollout: rollout, Devices: devices})
+}
+
+// HandleCancelRollout handles DELETE /firmware/rollouts/{id}
+func (e *FirmwareEndpoints) HandleCancelRollout(w http.ResponseWriter, r *http.Request) {
+	if err := e.firmwareService.CancelRollout(r.Context(), r.PathValue("id"), "api"); err != nil {
+		e.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleAdvanceRollout handles POST /firmware/rollouts/{id}/advance
+func (e *FirmwareEndpoints) HandleAdvanceRollout(w http.ResponseWriter, r *http.Request) {
+	rollout, err := e.firmwareService.AdvanceRollout(r.Context(), r.PathValue("id"), "api")
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.logger.Info("Firmware rollout advanced", "id", rollout.ID, "ring", rollout.Rings[rollout.Released-1].Name)
+	e.writeJSON(w, http.StatusOK, rollout)
+}
+
+// HandlePauseRollout handles POST /firmware/rollouts/{id}/pause
+func (e *FirmwareEndpoints) HandlePauseRollout(w http.ResponseWriter, r *http.Request) {
+	var req requests.FirmwarePauseRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	rollout, err := e.firmwareService.PauseRollout(r.Context(), r.PathValue("id"), req.Reason, "api")
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, rollout)
+}
+
+// HandleResumeRollout handles POST /firmware/rollouts/{id}/resume
+func (e *FirmwareEndpoints) HandleResumeRollout(w http.ResponseWriter, r *http.Request) {
+	rollout, err := e.firmwareService.ResumeRollout(r.Context(), r.PathValue("id"), "api")
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, rollout)
+}
+
+// HandleCheckUpdate handles GET /firmware/devices/{device}/manifest
+func (e *FirmwareEndpoints) HandleCheckUpdate(w http.ResponseWriter, r *http.Request) {
+	manifest, err := e.firmwareService.CheckUpdate(r.Context(), r.PathValue("device"), r.URL.Query().Get("version"))
+	if errors.Is(err, firmware.ErrNoUpdate) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, manifest)
+}
+
+// HandleGetDeviceStatus handles GET /firmware/devices/{device}
+func (e *FirmwareEndpoints) HandleGetDeviceStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := e.firmwareService.GetDeviceStatus(r.Context(), r.PathValue("device"))
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, status)
+}
+
+// HandleReportStatus handles POST /firmware/devices/{device}/status
+func (e *FirmwareEndpoints) HandleReportStatus(w http.ResponseWriter, r *http.Request) {
+	var req requests.FirmwareStatusRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	device := r.PathValue("device")
+	status, err := e.firmwareService.ReportStatus(r.Context(), device, firmware.StatusReport{ImageID: req.ImageID, State: req.State, Detail: req.Detail})
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, status)
+}
+
+func (e *FirmwareEndpoints) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, firmware.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, firmware.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, firmware.ErrExists), errors.Is(err, firmware.ErrInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, firmware.ErrDenied):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		e.logger.Error("Firmware operation failed", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (e *FirmwareEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode firmware response", "error", err)
+	}
+}
@@ -0,0 +1,39 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+)
+
+type FsckEndpoints struct {
+	fsckService services.FsckService
+	logger      *slog.Logger
+}
+
+func NewFsckEndpoints(fsckService services.FsckService, logger *slog.Logger) *FsckEndpoints {
+	return &FsckEndpoints{
+		fsckService: fsckService,
+		logger:      logger,
+	}
+}
+
+// HandleCheck handles GET /storage/fsck
+func (e *FsckEndpoints) HandleCheck(w http.ResponseWriter, r *http.Request) {
+	report, err := e.fsckService.Check(r.Context())
+	if err != nil {
+		e.logger.Error("Storage check failed", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(report.Violations) > 0 {
+		e.logger.Warn("Storage check found broken invariants", "violations", len(report.Violations))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		e.logger.Error("Failed to encode storage check", "error", err)
+	}
+}
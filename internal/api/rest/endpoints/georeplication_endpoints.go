@@ -0,0 +1,40 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+)
+
+type GeoReplicationEndpoints struct {
+	replicationService services.GeoReplicationService
+	logger             *slog.Logger
+}
+
+func NewGeoReplicationEndpoints(replicationService services.GeoReplicationService, logger *slog.Logger) *GeoReplicationEndpoints {
+	return &GeoReplicationEndpoints{
+		replicationService: replicationService,
+		logger:             logger,
+	}
+}
+
+// HandleStatus handles GET /replication/status
+func (e *GeoReplicationEndpoints) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := e.replicationService.GetStatus(r.Context())
+	if err != nil {
+		e.logger.Error("Failed to get replication status", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, status)
+}
+
+func (e *GeoReplicationEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode response", "error", err)
+	}
+}
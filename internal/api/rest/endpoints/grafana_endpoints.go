@@ -0,0 +1,132 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/grafana"
+)
+
+// GrafanaEndpoints serve the JSON datasource protocol of Grafana
+type GrafanaEndpoints struct {
+	grafanaService services.GrafanaService
+	logger         *slog.Logger
+}
+
+func NewGrafanaEndpoints(grafanaService services.GrafanaService, logger *slog.Logger) *GrafanaEndpoints {
+	return &GrafanaEndpoints{
+		grafanaService: grafanaService,
+		logger:         logger,
+	}
+}
+
+// HandleTest handles GET /grafana/, the connection test of the datasource
+func (e *GrafanaEndpoints) HandleTest(w http.ResponseWriter, r *http.Request) {
+	e.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// HandleSearch handles POST /grafana/search
+func (e *GrafanaEndpoints) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	var req requests.GrafanaSearchRequest
+	if !e.decode(w, r, &req) {
+		return
+	}
+
+	targets, err := e.grafanaService.Search(r.Context(), req.Target)
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, targets)
+}
+
+// HandleMetrics handles POST /grafana/metrics, the metric search of newer
+// versions of the plugin
+func (e *GrafanaEndpoints) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	targets, err := e.grafanaService.Search(r.Context(), "")
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	metrics := make([]responses.GrafanaMetric, len(targets))
+	for i, t := range targets {
+		metrics[i] = responses.GrafanaMetric{Label: t, Value: t}
+	}
+	e.writeJSON(w, http.StatusOK, metrics)
+}
+
+// HandleQuery handles POST /grafana/query
+func (e *GrafanaEndpoints) HandleQuery(w http.ResponseWriter, r *http.Request) {
+	var req grafana.QueryRequest
+	if !e.decode(w, r, &req) {
+		return
+	}
+
+	results, err := e.grafanaService.Query(r.Context(), &req)
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, results)
+}
+
+// HandleTagKeys handles POST /grafana/tag-keys
+func (e *GrafanaEndpoints) HandleTagKeys(w http.ResponseWriter, r *http.Request) {
+	keys := make([]responses.GrafanaTagKey, len(grafana.Labels))
+	for i, label := range grafana.Labels {
+		keys[i] = responses.GrafanaTagKey{Type: "string", Text: label}
+	}
+	e.writeJSON(w, http.StatusOK, keys)
+}
+
+// HandleTagValues handles POST /grafana/tag-values
+func (e *GrafanaEndpoints) HandleTagValues(w http.ResponseWriter, r *http.Request) {
+	var req requests.GrafanaTagValuesRequest
+	if !e.decode(w, r, &req) {
+		return
+	}
+
+	values, err := e.grafanaService.LabelValues(r.Context(), req.Key)
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	tags := make([]responses.GrafanaTagValue, len(values))
+	for i, v := range values {
+		tags[i] = responses.GrafanaTagValue{Text: v}
+	}
+	e.writeJSON(w, http.StatusOK, tags)
+}
+
+// decode reads a JSON body; Grafana posts an empty one to some endpoints
+func (e *GrafanaEndpoints) decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(v)
+	if err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (e *GrafanaEndpoints) writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, grafana.ErrInvalidQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e.logger.Error("Grafana query failed", "error", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func (e *GrafanaEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode Grafana response", "error", err)
+	}
+}
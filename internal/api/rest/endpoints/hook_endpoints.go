@@ -0,0 +1,45 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/hooks"
+)
+
+type HookEndpoints struct {
+	hookService services.HookService
+	logger      *slog.Logger
+}
+
+func NewHookEndpoints(hookService services.HookService, logger *slog.Logger) *HookEndpoints {
+	return &HookEndpoints{
+		hookService: hookService,
+		logger:      logger,
+	}
+}
+
+// HandleListHooks handles GET /hooks
+func (e *HookEndpoints) HandleListHooks(w http.ResponseWriter, r *http.Request) {
+	stats, err := e.hookService.ListHooks(r.Context())
+	if err != nil {
+		e.logger.Error("Failed to list hooks", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if stats == nil {
+		stats = []hooks.Stats{}
+	}
+	e.writeJSON(w, http.StatusOK, responses.HookListResponse{Hooks: stats, Total: len(stats)})
+}
+
+func (e *HookEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode hook response", "error", err)
+	}
+}
@@ -0,0 +1,96 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/scheduler"
+)
+
+type JobEndpoints struct {
+	jobService services.JobService
+	logger     *slog.Logger
+}
+
+func NewJobEndpoints(jobService services.JobService, logger *slog.Logger) *JobEndpoints {
+	return &JobEndpoints{
+		jobService: jobService,
+		logger:     logger,
+	}
+}
+
+// HandleListJobs handles GET /jobs
+func (e *JobEndpoints) HandleListJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := e.jobService.ListJobs(r.Context())
+	e.writeJSON(w, http.StatusOK, responses.JobListResponse{Jobs: jobs, Total: len(jobs)})
+}
+
+// HandleGetJob handles GET /jobs/{name}
+func (e *JobEndpoints) HandleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := e.jobService.GetJob(r.Context(), r.PathValue("name"))
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, job)
+}
+
+// HandleUpdateJob handles PATCH /jobs/{name}
+func (e *JobEndpoints) HandleUpdateJob(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var req requests.JobRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	job, err := e.jobService.UpdateJob(r.Context(), name, &req)
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Job updated", "name", name, "schedule", job.Schedule, "paused", job.Paused)
+	e.writeJSON(w, http.StatusOK, job)
+}
+
+// HandleRunJob handles POST /jobs/{name}/run
+func (e *JobEndpoints) HandleRunJob(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	run, err := e.jobService.RunJob(r.Context(), name)
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Job triggered", "name", name, "run", run.ID)
+	e.writeJSON(w, http.StatusAccepted, run)
+}
+
+func (e *JobEndpoints) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, scheduler.ErrNotFound):
+		http.Error(w, "Job not found", http.StatusNotFound)
+	case errors.Is(err, scheduler.ErrRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, scheduler.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		e.logger.Error("Job request failed", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (e *JobEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode job response", "error", err)
+	}
+}
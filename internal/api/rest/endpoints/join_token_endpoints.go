@@ -0,0 +1,81 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/join"
+)
+
+type JoinTokenEndpoints struct {
+	joinService services.JoinTokenService
+	logger      *slog.Logger
+}
+
+func NewJoinTokenEndpoints(joinService services.JoinTokenService, logger *slog.Logger) *JoinTokenEndpoints {
+	return &JoinTokenEndpoints{
+		joinService: joinService,
+		logger:      logger,
+	}
+}
+
+// HandleListTokens handles GET /peers/join-tokens
+func (e *JoinTokenEndpoints) HandleListTokens(w http.ResponseWriter, r *http.Request) {
+	tokens := e.joinService.ListTokens(r.Context())
+	e.writeJSON(w, http.StatusOK, responses.JoinTokenListResponse{Tokens: tokens, Total: len(tokens)})
+}
+
+// HandleCreateToken handles POST /peers/join-tokens
+func (e *JoinTokenEndpoints) HandleCreateToken(w http.ResponseWriter, r *http.Request) {
+	var req requests.JoinTokenRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	record, token, err := e.joinService.CreateToken(r.Context(), &req, "api")
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Join token created", "id", record.ID, "expires_at", record.ExpiresAt, "max_uses", record.MaxUses)
+	e.writeJSON(w, http.StatusCreated, responses.JoinTokenResponse{Record: record, Token: token})
+}
+
+// HandleRevokeToken handles DELETE /peers/join-tokens/{id}
+func (e *JoinTokenEndpoints) HandleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := e.joinService.RevokeToken(r.Context(), id, "api"); err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Join token revoked", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *JoinTokenEndpoints) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, join.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, join.ErrInvalidToken):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		e.logger.Error("Join token change failed", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (e *JoinTokenEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode join token response", "error", err)
+	}
+}
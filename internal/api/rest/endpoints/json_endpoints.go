@@ -0,0 +1,147 @@
+package endpoints
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"github.com/Skpow1234/Peervault/internal/jsondoc"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+	"github.com/Skpow1234/Peervault/internal/retention"
+)
+
+type JSONEndpoints struct {
+	jsonService services.JSONService
+	logger      *slog.Logger
+}
+
+func NewJSONEndpoints(jsonService services.JSONService, logger *slog.Logger) *JSONEndpoints {
+	return &JSONEndpoints{
+		jsonService: jsonService,
+		logger:      logger,
+	}
+}
+
+// HandleGetJSON handles GET /json/{key}
+func (e *JSONEndpoints) HandleGetJSON(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	file, doc, err := e.jsonService.GetJSON(r.Context(), key)
+	if err != nil {
+		e.writeError(w, key, err)
+		return
+	}
+	w.Header().Set("Content-Type", jsondoc.ContentType)
+	w.Header().Set("ETag", `"`+file.Hash+`"`)
+	http.ServeContent(w, r, "", file.UpdatedAt, bytes.NewReader(doc))
+}
+
+// HandlePutJSON handles PUT /json/{key}
+func (e *JSONEndpoints) HandlePutJSON(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	body, ok := readDocument(w, r)
+	if !ok {
+		return
+	}
+	file, doc, created, err := e.jsonService.PutJSON(r.Context(), key, body, condition(r))
+	if err != nil {
+		e.writeError(w, key, err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeDocument(w, status, file.Hash, doc)
+}
+
+// HandlePatchJSON handles PATCH /json/{key}
+func (e *JSONEndpoints) HandlePatchJSON(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	patchType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if patchType != jsondoc.PatchType && patchType != jsondoc.MergePatchType {
+		w.Header().Set("Accept-Patch", jsondoc.PatchType+", "+jsondoc.MergePatchType)
+		http.Error(w, "Content-Type must be "+jsondoc.PatchType+" or "+jsondoc.MergePatchType, http.StatusUnsupportedMediaType)
+		return
+	}
+	patch, ok := readDocument(w, r)
+	if !ok {
+		return
+	}
+	file, doc, err := e.jsonService.PatchJSON(r.Context(), key, patchType, patch, condition(r))
+	if err != nil {
+		e.writeError(w, key, err)
+		return
+	}
+	writeDocument(w, http.StatusOK, file.Hash, doc)
+}
+
+// HandleDeleteJSON handles DELETE /json/{key}
+func (e *JSONEndpoints) HandleDeleteJSON(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if err := e.jsonService.DeleteJSON(r.Context(), key, condition(r)); err != nil {
+		e.writeError(w, key, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// condition reads the preconditions of a write from its headers
+func condition(r *http.Request) jsondoc.Condition {
+	return jsondoc.Condition{
+		IfMatch:     jsondoc.ParseETags(r.Header.Get("If-Match")),
+		IfNoneMatch: strings.TrimSpace(r.Header.Get("If-None-Match")) == "*",
+	}
+}
+
+// readDocument reads a request body of at most jsondoc.MaxSize bytes
+func readDocument(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, jsondoc.MaxSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Documents are limited to 1 MiB", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		}
+		return nil, false
+	}
+	return body, true
+}
+
+func writeDocument(w http.ResponseWriter, status int, hash string, doc []byte) {
+	w.Header().Set("Content-Type", jsondoc.ContentType)
+	w.Header().Set("ETag", `"`+hash+`"`)
+	w.WriteHeader(status)
+	_, _ = w.Write(doc)
+}
+
+func (e *JSONEndpoints) writeError(w http.ResponseWriter, key string, err error) {
+	if writeStoreError(w, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, metadata.ErrNotFound):
+		http.Error(w, "Document not found", http.StatusNotFound)
+	case errors.Is(err, jsondoc.ErrNotDocument):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, jsondoc.ErrPrecondition):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	case errors.Is(err, jsondoc.ErrTestFailed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, jsondoc.ErrInvalid), errors.Is(err, directory.ErrInvalidPath):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, jsondoc.ErrPatch), errors.Is(err, jsondoc.ErrSchema):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, retention.ErrLocked):
+		http.Error(w, err.Error(), http.StatusLocked)
+	default:
+		e.logger.Error("Failed to handle JSON document", "key", key, "error", err)
+		http.Error(w, "Failed to handle JSON document", http.StatusInternalServerError)
+	}
+}
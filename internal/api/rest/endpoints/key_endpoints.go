@@ -0,0 +1,62 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+)
+
+type KeyEndpoints struct {
+	keyService services.KeyService
+	logger     *slog.Logger
+}
+
+func NewKeyEndpoints(keyService services.KeyService, logger *slog.Logger) *KeyEndpoints {
+	return &KeyEndpoints{
+		keyService: keyService,
+		logger:     logger,
+	}
+}
+
+// HandleGetStatus handles GET /keys
+func (e *KeyEndpoints) HandleGetStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := e.keyService.Status(r.Context())
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, status)
+}
+
+// HandleRotate handles POST /keys/rotate
+func (e *KeyEndpoints) HandleRotate(w http.ResponseWriter, r *http.Request) {
+	status, err := e.keyService.Rotate(r.Context())
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Encryption key rotated", "generation", status.Generation, "key_id", status.KeyID)
+	e.writeJSON(w, http.StatusAccepted, status)
+}
+
+func (e *KeyEndpoints) writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, fileserver.ErrNoKeyManager) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	e.logger.Error("Key rotation failed", "error", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func (e *KeyEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode key response", "error", err)
+	}
+}
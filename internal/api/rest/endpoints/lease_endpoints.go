@@ -0,0 +1,108 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/consensus"
+)
+
+type LeaseEndpoints struct {
+	leaseService services.LeaseService
+	logger       *slog.Logger
+}
+
+func NewLeaseEndpoints(leaseService services.LeaseService, logger *slog.Logger) *LeaseEndpoints {
+	return &LeaseEndpoints{
+		leaseService: leaseService,
+		logger:       logger,
+	}
+}
+
+// HandleListLeases handles GET /leases
+func (e *LeaseEndpoints) HandleListLeases(w http.ResponseWriter, r *http.Request) {
+	leases, err := e.leaseService.ListLeases(r.Context())
+	if err != nil {
+		e.writeError(w, "Failed to list leases", "", err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, responses.LeaseListResponse{Leases: leases, Total: len(leases)})
+}
+
+// HandleGetLease handles GET /leases/{name}
+func (e *LeaseEndpoints) HandleGetLease(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	lease, err := e.leaseService.GetLease(r.Context(), name)
+	if err != nil {
+		e.writeError(w, "Failed to get lease", name, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, lease)
+}
+
+// HandleAcquireLease handles PUT /leases/{name}
+func (e *LeaseEndpoints) HandleAcquireLease(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var req requests.LeaseAcquireRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	lease, err := e.leaseService.AcquireLease(r.Context(), name, &req)
+	if err != nil {
+		e.writeError(w, "Failed to acquire lease", name, err)
+		return
+	}
+
+	e.logger.Info("Lease acquired", "name", name, "holder", lease.Holder, "token", lease.Token, "expires_at", lease.ExpiresAt)
+	e.writeJSON(w, http.StatusOK, lease)
+}
+
+// HandleReleaseLease handles DELETE /leases/{name}?holder=
+func (e *LeaseEndpoints) HandleReleaseLease(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	holder := r.URL.Query().Get("holder")
+	if err := e.leaseService.ReleaseLease(r.Context(), name, holder); err != nil {
+		e.writeError(w, "Failed to release lease", name, err)
+		return
+	}
+
+	e.logger.Info("Lease released", "name", name, "holder", holder)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeError maps the answers of the Raft group to statuses: a lease held
+// by someone else conflicts, and a group that has no leader or fails
+// otherwise is unavailable. Requests rejected as invalid are bad requests.
+func (e *LeaseEndpoints) writeError(w http.ResponseWriter, msg, name string, err error) {
+	var remote *consensus.RemoteError
+	switch {
+	case errors.Is(err, consensus.ErrNotFound):
+		http.Error(w, "Lease not found", http.StatusNotFound)
+	case errors.Is(err, consensus.ErrLeaseHeld):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.As(err, &remote) && remote.Status == http.StatusBadRequest:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case remote != nil, errors.Is(err, consensus.ErrNoLeader), errors.Is(err, context.DeadlineExceeded):
+		e.logger.Error(msg, "name", name, "error", err)
+		http.Error(w, "Cluster metadata unavailable", http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func (e *LeaseEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode lease response", "error", err)
+	}
+}
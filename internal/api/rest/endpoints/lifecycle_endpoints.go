@@ -0,0 +1,115 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/lifecycle"
+)
+
+type LifecycleEndpoints struct {
+	lifecycleService services.LifecycleService
+	logger           *slog.Logger
+}
+
+func NewLifecycleEndpoints(lifecycleService services.LifecycleService, logger *slog.Logger) *LifecycleEndpoints {
+	return &LifecycleEndpoints{
+		lifecycleService: lifecycleService,
+		logger:           logger,
+	}
+}
+
+// HandleGetPolicy handles GET /lifecycle/policy
+func (e *LifecycleEndpoints) HandleGetPolicy(w http.ResponseWriter, r *http.Request) {
+	policy, err := e.lifecycleService.GetPolicy(r.Context())
+	if err != nil {
+		e.logger.Error("Failed to get lifecycle policy", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	e.writePolicy(w, policy)
+}
+
+// HandleSetPolicy handles PUT /lifecycle/policy
+func (e *LifecycleEndpoints) HandleSetPolicy(w http.ResponseWriter, r *http.Request) {
+	var req requests.LifecyclePolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	policy := lifecycle.Policy{Rules: req.Rules}
+	if err := e.lifecycleService.SetPolicy(r.Context(), policy); err != nil {
+		e.logger.Error("Failed to set lifecycle policy", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e.logger.Info("Lifecycle policy updated", "rules", len(policy.Rules))
+	e.writePolicy(w, policy)
+}
+
+// HandleRun handles POST /lifecycle/run?dry_run=true. Runs are dry unless
+// dry_run=false is given explicitly.
+func (e *LifecycleEndpoints) HandleRun(w http.ResponseWriter, r *http.Request) {
+	dryRun := true
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid dry_run parameter", http.StatusBadRequest)
+			return
+		}
+		dryRun = parsed
+	}
+
+	report, err := e.lifecycleService.Run(r.Context(), dryRun)
+	if err != nil {
+		if errors.Is(err, lifecycle.ErrRunInProgress) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		e.logger.Error("Lifecycle run failed", "dry_run", dryRun, "error", err)
+		http.Error(w, "Lifecycle run failed", http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, report)
+}
+
+// HandleGetReport handles GET /lifecycle/report
+func (e *LifecycleEndpoints) HandleGetReport(w http.ResponseWriter, r *http.Request) {
+	report, err := e.lifecycleService.LastReport(r.Context())
+	if err != nil {
+		e.logger.Error("Failed to get lifecycle report", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if report == nil {
+		http.Error(w, "No lifecycle run yet", http.StatusNotFound)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, report)
+}
+
+func (e *LifecycleEndpoints) writePolicy(w http.ResponseWriter, policy lifecycle.Policy) {
+	rules := policy.Rules
+	if rules == nil {
+		rules = []lifecycle.Rule{}
+	}
+	e.writeJSON(w, http.StatusOK, responses.LifecyclePolicyResponse{
+		Rules:     rules,
+		Enforcing: e.lifecycleService.Enforcing(),
+	})
+}
+
+func (e *LifecycleEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode response", "error", err)
+	}
+}
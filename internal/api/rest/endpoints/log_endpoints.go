@@ -0,0 +1,268 @@
+package endpoints
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/streamlog"
+)
+
+// NDJSONType is the content type of bodies of one JSON value per line
+const NDJSONType = "application/x-ndjson"
+
+// maxAppendSize is the largest body of an append
+const maxAppendSize = 16 << 20
+
+type LogEndpoints struct {
+	logService services.LogService
+	logger     *slog.Logger
+}
+
+func NewLogEndpoints(logService services.LogService, logger *slog.Logger) *LogEndpoints {
+	return &LogEndpoints{
+		logService: logService,
+		logger:     logger,
+	}
+}
+
+// HandleListLogs handles GET /logs
+func (e *LogEndpoints) HandleListLogs(w http.ResponseWriter, r *http.Request) {
+	logs, err := e.logService.ListLogs(r.Context())
+	if err != nil {
+		e.writeError(w, "", err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, responses.LogListResponse{Logs: logs, Total: len(logs)})
+}
+
+// HandleGetLog handles GET /logs/{name}
+func (e *LogEndpoints) HandleGetLog(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	info, err := e.logService.GetLog(r.Context(), name)
+	if err != nil {
+		e.writeError(w, name, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, info)
+}
+
+// HandleDeleteLog handles DELETE /logs/{name}
+func (e *LogEndpoints) HandleDeleteLog(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := e.logService.DeleteLog(r.Context(), name); err != nil {
+		e.writeError(w, name, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleAppendRecords handles POST /logs/{name}/records. The body is one
+// record, or one record per line when it is NDJSON.
+func (e *LogEndpoints) HandleAppendRecords(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAppendSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Appends are limited to 16 MiB", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		}
+		return
+	}
+
+	data := [][]byte{body}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == NDJSONType {
+		data = data[:0]
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		scanner.Buffer(nil, streamlog.MaxRecordSize+1)
+		for scanner.Scan() {
+			if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+				data = append(data, bytes.Clone(line))
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			e.writeError(w, name, streamlog.ErrTooLarge)
+			return
+		}
+	}
+	if len(data) == 0 || len(data[0]) == 0 {
+		http.Error(w, "No records to append", http.StatusBadRequest)
+		return
+	}
+
+	records, err := e.logService.AppendRecords(r.Context(), name, data)
+	if err != nil {
+		e.writeError(w, name, err)
+		return
+	}
+	// Answer with the offsets and times only
+	for i := range records {
+		records[i].Data = nil
+	}
+	e.writeJSON(w, http.StatusCreated, responses.LogRecordsResponse{Records: records, NextOffset: records[len(records)-1].Offset + 1})
+}
+
+// HandleReadRecords handles GET /logs/{name}/records. With follow=true the
+// records are streamed as NDJSON, and new ones as they are appended, until
+// the client goes away.
+func (e *LogEndpoints) HandleReadRecords(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	q, err := recordQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("follow") == "true" {
+		e.follow(w, r, name, q)
+		return
+	}
+
+	records, err := e.logService.ReadRecords(r.Context(), name, q)
+	if err != nil {
+		e.writeError(w, name, err)
+		return
+	}
+	next := q.Offset
+	if len(records) > 0 {
+		next = records[len(records)-1].Offset + 1
+	}
+	if records == nil {
+		records = []streamlog.Record{}
+	}
+	e.writeJSON(w, http.StatusOK, responses.LogRecordsResponse{Records: records, NextOffset: next})
+}
+
+func (e *LogEndpoints) follow(w http.ResponseWriter, r *http.Request, name string, q streamlog.Query) {
+	ctx := r.Context()
+	if _, err := e.logService.GetLog(ctx, name); err != nil {
+		e.writeError(w, name, err)
+		return
+	}
+	// A follow outlives the write timeout of the server
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", NDJSONType)
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	for {
+		records, err := e.logService.ReadRecords(ctx, name, q)
+		if err != nil {
+			return
+		}
+		for _, rec := range records {
+			if err := enc.Encode(rec); err != nil {
+				return
+			}
+			q.Offset = rec.Offset + 1
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		if len(records) < streamlog.DefaultReadLimit {
+			if err := e.logService.WaitRecords(ctx, name, q.Offset); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// recordQuery parses the offset, since and limit parameters of a read
+func recordQuery(r *http.Request) (streamlog.Query, error) {
+	var q streamlog.Query
+	params := r.URL.Query()
+	if v := params.Get("offset"); v != "" {
+		offset, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return q, errors.New("invalid offset parameter")
+		}
+		q.Offset = offset
+	}
+	if v := params.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, errors.New("invalid since parameter, expected RFC 3339")
+		}
+		q.Since = since
+	}
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > streamlog.DefaultReadLimit {
+			return q, errors.New("invalid limit parameter")
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+// HandleTruncateLog handles DELETE /logs/{name}/records, which drops the
+// records before the offset and those older than the time given
+func (e *LogEndpoints) HandleTruncateLog(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	params := r.URL.Query()
+	var offset uint64
+	var before time.Time
+	if v := params.Get("before_offset"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid before_offset parameter", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+	if v := params.Get("before_time"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "Invalid before_time parameter, expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+		before = t
+	}
+	if offset == 0 && before.IsZero() {
+		http.Error(w, "before_offset or before_time is required", http.StatusBadRequest)
+		return
+	}
+
+	info, err := e.logService.TruncateLog(r.Context(), name, offset, before)
+	if err != nil {
+		e.writeError(w, name, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, info)
+}
+
+func (e *LogEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode log response", "error", err)
+	}
+}
+
+func (e *LogEndpoints) writeError(w http.ResponseWriter, name string, err error) {
+	if writeStoreError(w, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, streamlog.ErrNotFound):
+		http.Error(w, "Log not found", http.StatusNotFound)
+	case errors.Is(err, streamlog.ErrInvalidName):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, streamlog.ErrTooLarge):
+		http.Error(w, "Records are limited to 1 MiB", http.StatusRequestEntityTooLarge)
+	default:
+		e.logger.Error("Failed to handle log", "log", name, "error", err)
+		http.Error(w, "Failed to handle log", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,168 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/lwm2m"
+)
+
+type LwM2MEndpoints struct {
+	lwm2mService services.LwM2MService
+	logger       *slog.Logger
+}
+
+func NewLwM2MEndpoints(lwm2mService services.LwM2MService, logger *slog.Logger) *LwM2MEndpoints {
+	return &LwM2MEndpoints{
+		lwm2mService: lwm2mService,
+		logger:       logger,
+	}
+}
+
+// HandleListClients handles GET /lwm2m/clients
+func (e *LwM2MEndpoints) HandleListClients(w http.ResponseWriter, r *http.Request) {
+	clients := e.lwm2mService.ListClients(r.Context())
+	e.writeJSON(w, http.StatusOK, responses.LwM2MClientListResponse{Clients: clients, Total: len(clients)})
+}
+
+// HandleGetClient handles GET /lwm2m/clients/{endpoint}
+func (e *LwM2MEndpoints) HandleGetClient(w http.ResponseWriter, r *http.Request) {
+	reg, err := e.lwm2mService.GetClient(r.Context(), r.PathValue("endpoint"))
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, reg)
+}
+
+// HandleRead handles GET /lwm2m/clients/{endpoint}/resources/{path...}
+func (e *LwM2MEndpoints) HandleRead(w http.ResponseWriter, r *http.Request) {
+	path := "/" + r.PathValue("path")
+	values, err := e.lwm2mService.Read(r.Context(), r.PathValue("endpoint"), path)
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, responses.LwM2MValuesResponse{Path: path, Values: values})
+}
+
+// HandleWrite handles PUT /lwm2m/clients/{endpoint}/resources/{path...}
+func (e *LwM2MEndpoints) HandleWrite(w http.ResponseWriter, r *http.Request) {
+	var req requests.LwM2MWriteRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	endpoint, path := r.PathValue("endpoint"), "/"+r.PathValue("path")
+	if err := e.lwm2mService.Write(r.Context(), endpoint, path, req.Value, "api"); err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("LwM2M resource written", "endpoint", endpoint, "path", path)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleExecute handles POST /lwm2m/clients/{endpoint}/resources/{path...}
+func (e *LwM2MEndpoints) HandleExecute(w http.ResponseWriter, r *http.Request) {
+	var req requests.LwM2MExecuteRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	endpoint, path := r.PathValue("endpoint"), "/"+r.PathValue("path")
+	if err := e.lwm2mService.Execute(r.Context(), endpoint, path, req.Arguments, "api"); err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("LwM2M resource executed", "endpoint", endpoint, "path", path)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleObserve handles POST /lwm2m/clients/{endpoint}/observations
+func (e *LwM2MEndpoints) HandleObserve(w http.ResponseWriter, r *http.Request) {
+	var req requests.LwM2MObserveRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	values, err := e.lwm2mService.Observe(r.Context(), r.PathValue("endpoint"), req.Path)
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeJSON(w, http.StatusCreated, responses.LwM2MValuesResponse{Path: req.Path, Values: values})
+}
+
+// HandleCancelObservation handles DELETE
+// /lwm2m/clients/{endpoint}/observations/{path...}
+func (e *LwM2MEndpoints) HandleCancelObservation(w http.ResponseWriter, r *http.Request) {
+	if err := e.lwm2mService.CancelObservation(r.Context(), r.PathValue("endpoint"), r.PathValue("path")); err != nil {
+		e.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleUpdateFirmware handles POST /lwm2m/clients/{endpoint}/firmware
+func (e *LwM2MEndpoints) HandleUpdateFirmware(w http.ResponseWriter, r *http.Request) {
+	var req requests.LwM2MFirmwareRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	endpoint := r.PathValue("endpoint")
+	update, err := e.lwm2mService.UpdateFirmware(r.Context(), endpoint, req.PackageURI, "api")
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("LwM2M firmware update started", "endpoint", endpoint, "package_uri", req.PackageURI)
+	e.writeJSON(w, http.StatusAccepted, update)
+}
+
+// HandleListObjects handles GET /lwm2m/objects
+func (e *LwM2MEndpoints) HandleListObjects(w http.ResponseWriter, r *http.Request) {
+	e.writeJSON(w, http.StatusOK, responses.LwM2MObjectListResponse{Objects: e.lwm2mService.ListObjects(r.Context())})
+}
+
+func (e *LwM2MEndpoints) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, lwm2m.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, lwm2m.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, lwm2m.ErrDenied):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, lwm2m.ErrNotAllowed):
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+	case errors.Is(err, lwm2m.ErrUnreachable):
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+	case errors.Is(err, lwm2m.ErrDevice):
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	default:
+		e.logger.Error("LwM2M operation failed", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (e *LwM2MEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode LwM2M response", "error", err)
+	}
+}
@@ -0,0 +1,117 @@
+package endpoints
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/media"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+)
+
+type MediaEndpoints struct {
+	mediaService services.MediaService
+	logger       *slog.Logger
+}
+
+func NewMediaEndpoints(mediaService services.MediaService, logger *slog.Logger) *MediaEndpoints {
+	return &MediaEndpoints{
+		mediaService: mediaService,
+		logger:       logger,
+	}
+}
+
+// HandleGetThumbnail handles GET /files/{key}/thumbnail
+func (e *MediaEndpoints) HandleGetThumbnail(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	size := 0
+	if v := r.URL.Query().Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid size parameter", http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+
+	file, thumb, err := e.mediaService.GetThumbnail(r.Context(), key, size)
+	if err != nil {
+		e.writeError(w, key, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	if file.Hash != "" {
+		w.Header().Set("ETag", fmt.Sprintf(`"%s-%d"`, file.Hash, size))
+	}
+	http.ServeContent(w, r, "", file.UpdatedAt, bytes.NewReader(thumb))
+}
+
+// HandleGetPlaylist handles GET /files/{key}/stream/master.m3u8 and
+// GET /files/{key}/stream/{profile}/index.m3u8
+func (e *MediaEndpoints) HandleGetPlaylist(w http.ResponseWriter, r *http.Request) {
+	key, profile := r.PathValue("key"), r.PathValue("profile")
+	file, playlist, err := e.mediaService.GetPlaylist(r.Context(), key, profile)
+	if err != nil {
+		e.writeError(w, key, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", media.PlaylistType)
+	// Playlists change with the file, so players revalidate them
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if file.Hash != "" {
+		w.Header().Set("ETag", fmt.Sprintf(`"%s-%s"`, file.Hash, profile))
+	}
+	http.ServeContent(w, r, "", file.UpdatedAt, bytes.NewReader(playlist))
+}
+
+// HandleGetSegment handles GET /files/{key}/stream/{profile}/{segment}
+func (e *MediaEndpoints) HandleGetSegment(w http.ResponseWriter, r *http.Request) {
+	key, profile := r.PathValue("key"), r.PathValue("profile")
+	name, ok := strings.CutSuffix(r.PathValue("segment"), ".ts")
+	n, err := strconv.Atoi(name)
+	if !ok || err != nil || n < 0 {
+		http.Error(w, "Segment not found", http.StatusNotFound)
+		return
+	}
+
+	file, segment, err := e.mediaService.GetSegment(r.Context(), key, profile, n)
+	if err != nil {
+		e.writeError(w, key, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", media.SegmentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	if file.Hash != "" {
+		w.Header().Set("ETag", fmt.Sprintf(`"%s-%s-%d"`, file.Hash, profile, n))
+	}
+	// ServeContent answers Range requests
+	http.ServeContent(w, r, "", file.UpdatedAt, bytes.NewReader(segment))
+}
+
+// writeError writes the status of an error making a preview or stream
+func (e *MediaEndpoints) writeError(w http.ResponseWriter, key string, err error) {
+	switch {
+	case errors.Is(err, metadata.ErrNotFound):
+		http.Error(w, "File not found", http.StatusNotFound)
+	case errors.Is(err, media.ErrProfile), errors.Is(err, media.ErrSegment):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, media.ErrSize):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, media.ErrUnsupported):
+		http.Error(w, "No preview or stream can be made of this file", http.StatusUnsupportedMediaType)
+	default:
+		e.logger.Error("Failed to make media", "key", key, "error", err)
+		http.Error(w, "Failed to make media", http.StatusInternalServerError)
+	}
+}
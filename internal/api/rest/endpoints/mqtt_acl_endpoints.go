@@ -0,0 +1,83 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/mqtt/topicacl"
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+)
+
+type MQTTACLEndpoints struct {
+	aclService services.MQTTACLService
+	logger     *slog.Logger
+}
+
+func NewMQTTACLEndpoints(aclService services.MQTTACLService, logger *slog.Logger) *MQTTACLEndpoints {
+	return &MQTTACLEndpoints{
+		aclService: aclService,
+		logger:     logger,
+	}
+}
+
+// HandleListRules handles GET /mqtt/acl
+func (e *MQTTACLEndpoints) HandleListRules(w http.ResponseWriter, r *http.Request) {
+	rules := e.aclService.ListRules(r.Context())
+	e.writeJSON(w, http.StatusOK, responses.MQTTACLRuleListResponse{Rules: rules, Total: len(rules)})
+}
+
+// HandleAddRule handles POST /mqtt/acl
+func (e *MQTTACLEndpoints) HandleAddRule(w http.ResponseWriter, r *http.Request) {
+	var req requests.MQTTACLRuleRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := e.aclService.AddRule(r.Context(), &req, "api")
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("MQTT ACL rule added", "id", rule.ID, "action", rule.Action, "access", rule.Access, "topic", rule.Topic, "device", rule.Device, "tenant", rule.Tenant)
+	e.writeJSON(w, http.StatusCreated, rule)
+}
+
+// HandleRemoveRule handles DELETE /mqtt/acl/{id}
+func (e *MQTTACLEndpoints) HandleRemoveRule(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := e.aclService.RemoveRule(r.Context(), id, "api"); err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("MQTT ACL rule removed", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *MQTTACLEndpoints) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, topicacl.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, topicacl.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, topicacl.ErrReadOnly):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		e.logger.Error("MQTT ACL change failed", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (e *MQTTACLEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode MQTT ACL response", "error", err)
+	}
+}
@@ -0,0 +1,83 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/acl"
+)
+
+type PeerACLEndpoints struct {
+	aclService services.PeerACLService
+	logger     *slog.Logger
+}
+
+func NewPeerACLEndpoints(aclService services.PeerACLService, logger *slog.Logger) *PeerACLEndpoints {
+	return &PeerACLEndpoints{
+		aclService: aclService,
+		logger:     logger,
+	}
+}
+
+// HandleListRules handles GET /peers/acl
+func (e *PeerACLEndpoints) HandleListRules(w http.ResponseWriter, r *http.Request) {
+	rules := e.aclService.ListRules(r.Context())
+	e.writeJSON(w, http.StatusOK, responses.PeerACLRuleListResponse{Rules: rules, Total: len(rules)})
+}
+
+// HandleAddRule handles POST /peers/acl
+func (e *PeerACLEndpoints) HandleAddRule(w http.ResponseWriter, r *http.Request) {
+	var req requests.PeerACLRuleRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := e.aclService.AddRule(r.Context(), &req, "api")
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Peer ACL rule added", "id", rule.ID, "action", rule.Action, "node_id", rule.NodeID, "cidr", rule.CIDR, "identity", rule.Identity)
+	e.writeJSON(w, http.StatusCreated, rule)
+}
+
+// HandleRemoveRule handles DELETE /peers/acl/{id}
+func (e *PeerACLEndpoints) HandleRemoveRule(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := e.aclService.RemoveRule(r.Context(), id, "api"); err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Peer ACL rule removed", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *PeerACLEndpoints) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, acl.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, acl.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, acl.ErrReadOnly):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		e.logger.Error("Peer ACL change failed", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (e *PeerACLEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode peer ACL response", "error", err)
+	}
+}
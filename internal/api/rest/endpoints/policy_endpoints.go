@@ -0,0 +1,66 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/policy"
+)
+
+type PolicyEndpoints struct {
+	policyService services.PolicyService
+	logger        *slog.Logger
+}
+
+func NewPolicyEndpoints(policyService services.PolicyService, logger *slog.Logger) *PolicyEndpoints {
+	return &PolicyEndpoints{
+		policyService: policyService,
+		logger:        logger,
+	}
+}
+
+// HandleGetPolicy handles GET /policy
+func (e *PolicyEndpoints) HandleGetPolicy(w http.ResponseWriter, r *http.Request) {
+	p, dryRun, err := e.policyService.GetPolicy(r.Context())
+	if err != nil {
+		e.logger.Error("Failed to get policy", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if p.Rules == nil {
+		p.Rules = []policy.Rule{}
+	}
+	e.writeJSON(w, http.StatusOK, responses.PolicyResponse{Rules: p.Rules, DryRun: dryRun})
+}
+
+// HandleListDecisions handles GET /policy/decisions?limit=&denied=
+func (e *PolicyEndpoints) HandleListDecisions(w http.ResponseWriter, r *http.Request) {
+	limit, err := intParam(r.URL.Query().Get("limit"), 100)
+	if err != nil {
+		http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+		return
+	}
+	denied := r.URL.Query().Get("denied") == "true"
+
+	decisions, err := e.policyService.ListDecisions(r.Context(), limit, denied)
+	if err != nil {
+		e.logger.Error("Failed to list policy decisions", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if decisions == nil {
+		decisions = []policy.Decision{}
+	}
+	e.writeJSON(w, http.StatusOK, responses.PolicyDecisionListResponse{Decisions: decisions, Total: len(decisions)})
+}
+
+func (e *PolicyEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode policy response", "error", err)
+	}
+}
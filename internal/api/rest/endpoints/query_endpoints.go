@@ -0,0 +1,77 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/sqlquery"
+)
+
+type QueryEndpoints struct {
+	queryService services.QueryService
+	logger       *slog.Logger
+}
+
+func NewQueryEndpoints(queryService services.QueryService, logger *slog.Logger) *QueryEndpoints {
+	return &QueryEndpoints{
+		queryService: queryService,
+		logger:       logger,
+	}
+}
+
+// HandleQuery handles POST /query
+func (e *QueryEndpoints) HandleQuery(w http.ResponseWriter, r *http.Request) {
+	var req requests.QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SQL == "" || req.MaxRows < 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	res, err := e.queryService.Query(r.Context(), req.SQL, req.MaxRows)
+	if err != nil {
+		if errors.Is(err, sqlquery.ErrInvalid) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		e.logger.Error("Query failed", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rows := res.Rows
+	if rows == nil {
+		rows = [][]any{}
+	}
+	e.writeJSON(w, http.StatusOK, responses.QueryResponse{
+		Columns:   res.Columns,
+		Rows:      rows,
+		Total:     len(rows),
+		Truncated: res.Truncated,
+	})
+}
+
+// HandleListTables handles GET /query/tables
+func (e *QueryEndpoints) HandleListTables(w http.ResponseWriter, r *http.Request) {
+	tables, err := e.queryService.ListTables(r.Context())
+	if err != nil {
+		e.logger.Error("Failed to list query tables", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	resp := responses.QueryTableListResponse{Tables: make([]responses.QueryTable, len(tables)), Total: len(tables)}
+	for i, t := range tables {
+		resp.Tables[i] = responses.QueryTable{Name: t.Name, Columns: t.Columns, Description: t.Doc}
+	}
+	e.writeJSON(w, http.StatusOK, resp)
+}
+
+func (e *QueryEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode query response", "error", err)
+	}
+}
@@ -0,0 +1,106 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/queue"
+)
+
+type QueueEndpoints struct {
+	queueService services.QueueService
+	logger       *slog.Logger
+}
+
+func NewQueueEndpoints(queueService services.QueueService, logger *slog.Logger) *QueueEndpoints {
+	return &QueueEndpoints{
+		queueService: queueService,
+		logger:       logger,
+	}
+}
+
+// HandleGetStats handles GET /queue
+func (e *QueueEndpoints) HandleGetStats(w http.ResponseWriter, r *http.Request) {
+	e.writeJSON(w, http.StatusOK, e.queueService.GetStats(r.Context()))
+}
+
+// HandleListJobs handles GET /queue/jobs
+func (e *QueueEndpoints) HandleListJobs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	state := queue.State(query.Get("state"))
+	switch state {
+	case "", queue.Pending, queue.Running, queue.Dead:
+	default:
+		http.Error(w, "state must be pending, running or dead", http.StatusBadRequest)
+		return
+	}
+	jobs := e.queueService.ListJobs(r.Context(), state, query.Get("kind"))
+	e.writeJSON(w, http.StatusOK, responses.QueueJobListResponse{Jobs: jobs, Total: len(jobs)})
+}
+
+// HandleGetJob handles GET /queue/jobs/{id}
+func (e *QueueEndpoints) HandleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := e.queueService.GetJob(r.Context(), r.PathValue("id"))
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, job)
+}
+
+// HandleRequeueJob handles POST /queue/jobs/{id}/requeue
+func (e *QueueEndpoints) HandleRequeueJob(w http.ResponseWriter, r *http.Request) {
+	job, err := e.queueService.RequeueJob(r.Context(), r.PathValue("id"))
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Dead job requeued", "id", job.ID, "kind", job.Kind)
+	e.writeJSON(w, http.StatusOK, job)
+}
+
+// HandleRequeueDead handles POST /queue/dead/requeue
+func (e *QueueEndpoints) HandleRequeueDead(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("kind")
+	n := e.queueService.RequeueDead(r.Context(), kind)
+
+	e.logger.Info("Dead jobs requeued", "kind", kind, "count", n)
+	e.writeJSON(w, http.StatusOK, responses.QueueRequeueResponse{Requeued: n})
+}
+
+// HandleDeleteJob handles DELETE /queue/jobs/{id}
+func (e *QueueEndpoints) HandleDeleteJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := e.queueService.DeleteJob(r.Context(), id); err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Queued job deleted", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *QueueEndpoints) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, queue.ErrNotFound):
+		http.Error(w, "Job not found", http.StatusNotFound)
+	case errors.Is(err, queue.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		e.logger.Error("Queue request failed", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (e *QueueEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode queue response", "error", err)
+	}
+}
@@ -0,0 +1,42 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+)
+
+type ReplicaEndpoints struct {
+	replicaService services.ReplicaService
+	logger         *slog.Logger
+}
+
+func NewReplicaEndpoints(replicaService services.ReplicaService, logger *slog.Logger) *ReplicaEndpoints {
+	return &ReplicaEndpoints{
+		replicaService: replicaService,
+		logger:         logger,
+	}
+}
+
+// HandleGetReplicas handles GET /files/{key}/replicas
+func (e *ReplicaEndpoints) HandleGetReplicas(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	report, err := e.replicaService.GetReplicas(r.Context(), key)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		e.logger.Error("Failed to get replicas", "key", key, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		e.logger.Error("Failed to encode response", "error", err)
+	}
+}
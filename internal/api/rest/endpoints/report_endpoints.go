@@ -0,0 +1,106 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/analytics"
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+)
+
+type ReportEndpoints struct {
+	reportService services.ReportService
+	logger        *slog.Logger
+}
+
+func NewReportEndpoints(reportService services.ReportService, logger *slog.Logger) *ReportEndpoints {
+	return &ReportEndpoints{
+		reportService: reportService,
+		logger:        logger,
+	}
+}
+
+// HandleListReports handles GET /analytics/reports
+func (e *ReportEndpoints) HandleListReports(w http.ResponseWriter, r *http.Request) {
+	reports := e.reportService.ListReports(r.Context())
+	e.writeJSON(w, http.StatusOK, responses.ReportListResponse{Reports: reports, Total: len(reports)})
+}
+
+// HandleGetReport handles GET /analytics/reports/{name}
+func (e *ReportEndpoints) HandleGetReport(w http.ResponseWriter, r *http.Request) {
+	report, err := e.reportService.GetReport(r.Context(), r.PathValue("name"))
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, report)
+}
+
+// HandlePutReport handles PUT /analytics/reports/{name}
+func (e *ReportEndpoints) HandlePutReport(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var req requests.ReportRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	report, err := e.reportService.PutReport(r.Context(), name, &req)
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Report saved", "name", name, "schedule", report.Schedule)
+	e.writeJSON(w, http.StatusOK, report)
+}
+
+// HandleDeleteReport handles DELETE /analytics/reports/{name}
+func (e *ReportEndpoints) HandleDeleteReport(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := e.reportService.DeleteReport(r.Context(), name); err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Report deleted", "name", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleRunReport handles POST /analytics/reports/{name}/run
+func (e *ReportEndpoints) HandleRunReport(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	run, err := e.reportService.RunReport(r.Context(), name)
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+
+	e.logger.Info("Report run", "name", name, "key", run.Key, "errors", len(run.Errors))
+	e.writeJSON(w, http.StatusOK, run)
+}
+
+func (e *ReportEndpoints) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, analytics.ErrReportNotFound):
+		http.Error(w, "Report not found", http.StatusNotFound)
+	case errors.Is(err, analytics.ErrInvalidReport):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		e.logger.Error("Report failed", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (e *ReportEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode report response", "error", err)
+	}
+}
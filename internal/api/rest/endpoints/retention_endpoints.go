@@ -0,0 +1,114 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/retention"
+)
+
+type RetentionEndpoints struct {
+	retentionService services.RetentionService
+	logger           *slog.Logger
+}
+
+func NewRetentionEndpoints(retentionService services.RetentionService, logger *slog.Logger) *RetentionEndpoints {
+	return &RetentionEndpoints{
+		retentionService: retentionService,
+		logger:           logger,
+	}
+}
+
+// HandleListLocks handles GET /locks
+func (e *RetentionEndpoints) HandleListLocks(w http.ResponseWriter, r *http.Request) {
+	locks, err := e.retentionService.ListLocks(r.Context())
+	if err != nil {
+		e.logger.Error("Failed to list locks", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := responses.FileLockListResponse{
+		Locks: make([]responses.FileLockResponse, len(locks)),
+		Total: len(locks),
+	}
+	for i := range locks {
+		response.Locks[i] = lockToResponse(&locks[i])
+	}
+	e.writeJSON(w, http.StatusOK, response)
+}
+
+// HandleGetLock handles GET /files/{key}/lock
+func (e *RetentionEndpoints) HandleGetLock(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	lock, err := e.retentionService.GetLock(r.Context(), key)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		e.logger.Error("Failed to get lock", "key", key, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, lockToResponse(lock))
+}
+
+// HandleUpdateLock handles PUT /files/{key}/lock
+func (e *RetentionEndpoints) HandleUpdateLock(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+
+	var req requests.FileLockUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	lock, err := e.retentionService.UpdateLock(r.Context(), key, &req, "api")
+	if err != nil {
+		e.logger.Error("Failed to update lock", "key", key, "error", err)
+		switch {
+		case errors.Is(err, retention.ErrShortenRetention):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case strings.Contains(err.Error(), "not found"):
+			http.Error(w, "File not found", http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	e.logger.Info("File lock updated", "key", key, "legal_hold", lock.LegalHold, "retain_until", lock.RetainUntil)
+	e.writeJSON(w, http.StatusOK, lockToResponse(lock))
+}
+
+func (e *RetentionEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode lock response", "error", err)
+	}
+}
+
+func lockToResponse(lock *retention.Lock) responses.FileLockResponse {
+	response := responses.FileLockResponse{
+		Key:       lock.Key,
+		Locked:    lock.Locked(time.Now()),
+		LegalHold: lock.LegalHold,
+		UpdatedBy: lock.UpdatedBy,
+	}
+	if !lock.RetainUntil.IsZero() {
+		response.RetainUntil = &lock.RetainUntil
+	}
+	if !lock.UpdatedAt.IsZero() {
+		response.UpdatedAt = &lock.UpdatedAt
+	}
+	return response
+}
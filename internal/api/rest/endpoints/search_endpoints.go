@@ -0,0 +1,134 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/search"
+)
+
+type SearchEndpoints struct {
+	searchService services.SearchService
+	logger        *slog.Logger
+}
+
+func NewSearchEndpoints(searchService services.SearchService, logger *slog.Logger) *SearchEndpoints {
+	return &SearchEndpoints{
+		searchService: searchService,
+		logger:        logger,
+	}
+}
+
+// HandleSearch handles GET /search?q=...&limit=&offset=&highlight=
+func (e *SearchEndpoints) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := query.Get("q")
+	if q == "" {
+		http.Error(w, "Missing q parameter", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := intParam(query.Get("limit"), 20)
+	if err != nil {
+		http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+		return
+	}
+	offset, err := intParam(query.Get("offset"), 0)
+	if err != nil {
+		http.Error(w, "Invalid offset parameter", http.StatusBadRequest)
+		return
+	}
+	highlight := query.Get("highlight") != "false"
+
+	results, err := e.searchService.Search(r.Context(), search.Query{Text: q, Limit: limit, Offset: offset, Highlight: highlight})
+	if err != nil {
+		e.logger.Error("Search failed", "query", q, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := responses.SearchResponse{
+		Query:   results.Query,
+		Total:   results.Total,
+		Limit:   limit,
+		Offset:  offset,
+		TookMs:  float64(results.Took) / float64(time.Millisecond),
+		Results: make([]responses.SearchHitResponse, len(results.Results)),
+	}
+	for i, hit := range results.Results {
+		response.Results[i] = responses.SearchHitResponse(hit)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleSearchStatus handles GET /search/status
+func (e *SearchEndpoints) HandleSearchStatus(w http.ResponseWriter, r *http.Request) {
+	stats, err := e.searchService.Status(r.Context())
+	if err != nil {
+		e.logger.Error("Failed to get search status", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	e.writeStatus(w, http.StatusOK, stats)
+}
+
+// HandleRebuildIndex handles POST /search/rebuild
+func (e *SearchEndpoints) HandleRebuildIndex(w http.ResponseWriter, r *http.Request) {
+	if err := e.searchService.Rebuild(r.Context()); err != nil {
+		if errors.Is(err, search.ErrRebuildInProgress) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		e.logger.Error("Failed to start index rebuild", "error", err)
+		http.Error(w, "Failed to start index rebuild", http.StatusInternalServerError)
+		return
+	}
+
+	stats, err := e.searchService.Status(r.Context())
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	e.writeStatus(w, http.StatusAccepted, stats)
+}
+
+func (e *SearchEndpoints) writeStatus(w http.ResponseWriter, code int, stats *search.Stats) {
+	response := responses.SearchStatusResponse{
+		Documents:  stats.Documents,
+		Terms:      stats.Terms,
+		Rebuilding: stats.Rebuilding,
+		Persistent: stats.Persistent,
+		LastError:  stats.LastError,
+	}
+	if !stats.LastRebuild.IsZero() {
+		response.LastRebuild = &stats.LastRebuild
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		e.logger.Error("Failed to encode search status", "error", err)
+	}
+}
+
+func intParam(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid integer")
+	}
+	return n, nil
+}
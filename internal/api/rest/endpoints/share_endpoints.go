@@ -0,0 +1,185 @@
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/clientip"
+	"github.com/Skpow1234/Peervault/internal/policy"
+	"github.com/Skpow1234/Peervault/internal/sharing"
+)
+
+// SharePasswordHeader carries the password of a protected share link
+const SharePasswordHeader = "X-Share-Password"
+
+type ShareEndpoints struct {
+	shareService services.ShareService
+	logger       *slog.Logger
+}
+
+func NewShareEndpoints(shareService services.ShareService, logger *slog.Logger) *ShareEndpoints {
+	return &ShareEndpoints{
+		shareService: shareService,
+		logger:       logger,
+	}
+}
+
+// HandleCreateLink handles POST /shares
+func (e *ShareEndpoints) HandleCreateLink(w http.ResponseWriter, r *http.Request) {
+	var req requests.ShareLinkCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Key == "" {
+		http.Error(w, "Missing key", http.StatusBadRequest)
+		return
+	}
+
+	link, _, err := e.shareService.CreateLink(r.Context(), &req, "api")
+	if err != nil {
+		e.logger.Error("Failed to create share link", "key", req.Key, "error", err)
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, policy.ErrDenied) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e.writeJSON(w, http.StatusCreated, e.toResponse(link))
+}
+
+// HandleListLinks handles GET /shares?key=
+func (e *ShareEndpoints) HandleListLinks(w http.ResponseWriter, r *http.Request) {
+	links, err := e.shareService.ListLinks(r.Context(), r.URL.Query().Get("key"))
+	if err != nil {
+		e.logger.Error("Failed to list share links", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := responses.ShareLinkListResponse{
+		Links: make([]responses.ShareLinkResponse, len(links)),
+		Total: len(links),
+	}
+	for i := range links {
+		response.Links[i] = e.toResponse(&links[i])
+	}
+	e.writeJSON(w, http.StatusOK, response)
+}
+
+// HandleGetLink handles GET /shares/{id}
+func (e *ShareEndpoints) HandleGetLink(w http.ResponseWriter, r *http.Request) {
+	link, err := e.shareService.GetLink(r.Context(), r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, e.toResponse(link))
+}
+
+// HandleRevokeLink handles DELETE /shares/{id}
+func (e *ShareEndpoints) HandleRevokeLink(w http.ResponseWriter, r *http.Request) {
+	link, err := e.shareService.RevokeLink(r.Context(), r.PathValue("id"), "api")
+	if err != nil {
+		if errors.Is(err, sharing.ErrNotFound) {
+			http.Error(w, "Share link not found", http.StatusNotFound)
+			return
+		}
+		e.logger.Error("Failed to revoke share link", "id", r.PathValue("id"), "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, e.toResponse(link))
+}
+
+// HandleOpenLink handles the unauthenticated GET /s/{id}?exp=&sig= download.
+// Passwords are read from the X-Share-Password header or, so browsers can
+// prompt for them, from HTTP basic auth.
+func (e *ShareEndpoints) HandleOpenLink(w http.ResponseWriter, r *http.Request) {
+	access := sharing.Access{
+		ID:        r.PathValue("id"),
+		Expires:   r.URL.Query().Get("exp"),
+		Signature: r.URL.Query().Get("sig"),
+		Password:  r.Header.Get(SharePasswordHeader),
+		IPAddress: clientip.FromRequest(r),
+		UserAgent: r.UserAgent(),
+	}
+	if access.Password == "" {
+		if _, password, ok := r.BasicAuth(); ok {
+			access.Password = password
+		}
+	}
+
+	file, data, err := e.shareService.OpenLink(r.Context(), access)
+	if err != nil {
+		switch {
+		case errors.Is(err, sharing.ErrPasswordRequired), errors.Is(err, sharing.ErrPasswordMismatch):
+			w.Header().Set("WWW-Authenticate", `Basic realm="PeerVault shared file"`)
+			http.Error(w, "Password required", http.StatusUnauthorized)
+		case errors.Is(err, sharing.ErrInvalidSignature):
+			http.Error(w, "Invalid share link", http.StatusForbidden)
+		case errors.Is(err, sharing.ErrExpired), errors.Is(err, sharing.ErrRevoked), errors.Is(err, sharing.ErrDownloadLimit):
+			http.Error(w, "Share link is no longer available", http.StatusGone)
+		default:
+			if !errors.Is(err, sharing.ErrNotFound) {
+				e.logger.Error("Failed to open share link", "id", access.ID, "error", err)
+			}
+			http.Error(w, "Share link not found", http.StatusNotFound)
+		}
+		return
+	}
+
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+strings.ReplaceAll(file.Name, `"`, "")+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+func (e *ShareEndpoints) toResponse(link *sharing.Link) responses.ShareLinkResponse {
+	response := responses.ShareLinkResponse{
+		ID:                link.ID,
+		Key:               link.Key,
+		URL:               e.shareService.LinkURL(link),
+		CreatedBy:         link.CreatedBy,
+		CreatedAt:         link.CreatedAt,
+		ExpiresAt:         link.ExpiresAt,
+		MaxDownloads:      link.MaxDownloads,
+		Downloads:         link.Downloads,
+		PasswordProtected: link.HasPassword(),
+		Revoked:           link.Revoked,
+		Active:            link.Active(time.Now()),
+	}
+	if !link.LastAccessed.IsZero() {
+		response.LastAccessed = &link.LastAccessed
+	}
+	if !link.RevokedAt.IsZero() {
+		response.RevokedAt = &link.RevokedAt
+	}
+	return response
+}
+
+func (e *ShareEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode share response", "error", err)
+	}
+}
@@ -0,0 +1,178 @@
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/backup"
+	"github.com/Skpow1234/Peervault/internal/snapshot"
+)
+
+type SnapshotEndpoints struct {
+	snapshotService services.SnapshotService
+	logger          *slog.Logger
+}
+
+func NewSnapshotEndpoints(snapshotService services.SnapshotService, logger *slog.Logger) *SnapshotEndpoints {
+	return &SnapshotEndpoints{
+		snapshotService: snapshotService,
+		logger:          logger,
+	}
+}
+
+// HandleListSnapshots handles GET /snapshots
+func (e *SnapshotEndpoints) HandleListSnapshots(w http.ResponseWriter, r *http.Request) {
+	snaps, err := e.snapshotService.ListSnapshots(r.Context())
+	if err != nil {
+		e.writeError(w, "Failed to list snapshots", "", err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, responses.SnapshotListResponse{Snapshots: snaps, Total: len(snaps)})
+}
+
+// HandleCreateSnapshot handles POST /snapshots
+func (e *SnapshotEndpoints) HandleCreateSnapshot(w http.ResponseWriter, r *http.Request) {
+	var req requests.SnapshotCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	snap, err := e.snapshotService.CreateSnapshot(r.Context(), req.Namespace, req.Name)
+	if err != nil {
+		e.writeError(w, "Failed to create snapshot", "", err)
+		return
+	}
+	snap.Entries = nil
+	e.writeJSON(w, http.StatusCreated, snap)
+}
+
+// HandleGetSnapshot handles GET /snapshots/{id}
+func (e *SnapshotEndpoints) HandleGetSnapshot(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	snap, err := e.snapshotService.GetSnapshot(r.Context(), id)
+	if err != nil {
+		e.writeError(w, "Failed to get snapshot", id, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, snap)
+}
+
+// HandleDeleteSnapshot handles DELETE /snapshots/{id}
+func (e *SnapshotEndpoints) HandleDeleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := e.snapshotService.DeleteSnapshot(r.Context(), id); err != nil {
+		e.writeError(w, "Failed to delete snapshot", id, err)
+		return
+	}
+	e.logger.Info("Snapshot deleted", "snapshot", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleDownloadFile handles GET /snapshots/{id}/files/{key...}, serving a
+// file read-only as the snapshot recorded it
+func (e *SnapshotEndpoints) HandleDownloadFile(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	entry, data, err := e.snapshotService.DownloadFile(r.Context(), id, r.PathValue("key"))
+	if err != nil {
+		e.writeError(w, "Failed to read snapshot file", id, err)
+		return
+	}
+
+	contentType := entry.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", `"`+entry.Hash+`"`)
+	name := entry.Name
+	if name == "" {
+		name = path.Base(entry.Key)
+	}
+	http.ServeContent(w, r, name, entry.ModTime, bytes.NewReader(data))
+}
+
+// HandleDiff handles GET /snapshots/{id}/diff?to=, comparing with another
+// snapshot or, without to, with the live files
+func (e *SnapshotEndpoints) HandleDiff(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	diff, err := e.snapshotService.Diff(r.Context(), id, r.URL.Query().Get("to"))
+	if err != nil {
+		e.writeError(w, "Failed to diff snapshots", id, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, diff)
+}
+
+// HandleClone handles POST /snapshots/{id}/clone
+func (e *SnapshotEndpoints) HandleClone(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req requests.SnapshotCloneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	report, err := e.snapshotService.Clone(r.Context(), id, req.Prefix, req.Overwrite)
+	if err != nil {
+		e.writeError(w, "Clone failed", id, err)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, report)
+}
+
+// HandleBackup handles POST /snapshots/{id}/backup, running a backup job
+// from the snapshot
+func (e *SnapshotEndpoints) HandleBackup(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req requests.SnapshotBackupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Job == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	typ := backup.BackupTypeIncremental
+	if req.Type != "" {
+		typ = backup.BackupType(req.Type)
+		if typ != backup.BackupTypeFull && typ != backup.BackupTypeIncremental {
+			http.Error(w, "Invalid type", http.StatusBadRequest)
+			return
+		}
+	}
+
+	snap, err := e.snapshotService.Backup(r.Context(), id, req.Job, typ)
+	if err != nil {
+		e.writeError(w, "Backup failed", id, err)
+		return
+	}
+	snap.Entries = nil
+	e.writeJSON(w, http.StatusOK, snap)
+}
+
+// writeError maps snapshot errors to status codes
+func (e *SnapshotEndpoints) writeError(w http.ResponseWriter, msg, id string, err error) {
+	switch {
+	case errors.Is(err, snapshot.ErrNotFound), errors.Is(err, snapshot.ErrKeyNotFound), errors.Is(err, backup.ErrJobNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, snapshot.ErrContentLost):
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, backup.ErrJobRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		e.logger.Error(msg, "snapshot", id, "error", err)
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
+}
+
+func (e *SnapshotEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode response", "error", err)
+	}
+}
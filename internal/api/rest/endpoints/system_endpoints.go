@@ -6,15 +6,19 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/Skpow1234/Peervault/internal/api/rest/openapi"
 	"github.com/Skpow1234/Peervault/internal/api/rest/services"
 	"github.com/Skpow1234/Peervault/internal/api/rest/types"
 	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
 	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/cache"
 )
 
 type SystemEndpoints struct {
 	systemService services.SystemService
 	logger        *slog.Logger
+	openAPI       *openapi.Document
+	gatewayCache  func() (cache.ByteCacheStats, bool)
 }
 
 func NewSystemEndpoints(systemService services.SystemService, logger *slog.Logger) *SystemEndpoints {
@@ -59,6 +63,11 @@ func (e *SystemEndpoints) HandleMetrics(w http.ResponseWriter, r *http.Request)
 	}
 
 	response := types.MetricsToResponse(metrics)
+	if e.gatewayCache != nil {
+		if stats, ok := e.gatewayCache(); ok {
+			response.GatewayCache = &stats
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
@@ -115,6 +124,11 @@ func (e *SystemEndpoints) HandleRoot(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// docsCSP lets the Swagger UI load its bundle from the CDN and fetch the
+// specification
+const docsCSP = "default-src 'self'; script-src 'self' 'unsafe-inline' https://unpkg.com; style-src 'self' 'unsafe-inline' https://unpkg.com; " +
+	"img-src 'self' data: https://unpkg.com; connect-src 'self'; frame-ancestors 'none'"
+
 func (e *SystemEndpoints) HandleDocs(w http.ResponseWriter, r *http.Request) {
 	swaggerHTML := `<!DOCTYPE html>
 <html lang="en">
@@ -149,102 +163,33 @@ func (e *SystemEndpoints) HandleDocs(w http.ResponseWriter, r *http.Request) {
 </html>`
 
 	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Content-Security-Policy", docsCSP)
 	if _, err := w.Write([]byte(swaggerHTML)); err != nil {
 		http.Error(w, "Failed to write response", http.StatusInternalServerError)
 		return
 	}
 }
 
+// SetOpenAPI sets the document served by HandleSwaggerJSON
+func (e *SystemEndpoints) SetOpenAPI(doc *openapi.Document) {
+	e.openAPI = doc
+}
+
+// SetGatewayCache sets where HandleMetrics reads the counters of the
+// public gateway's cache
+func (e *SystemEndpoints) SetGatewayCache(stats func() (cache.ByteCacheStats, bool)) {
+	e.gatewayCache = stats
+}
+
+// HandleSwaggerJSON handles GET /swagger.json
 func (e *SystemEndpoints) HandleSwaggerJSON(w http.ResponseWriter, r *http.Request) {
-	swaggerSpec := map[string]interface{}{
-		"openapi": "3.0.3",
-		"info": map[string]interface{}{
-			"title":       "PeerVault REST API",
-			"description": "PeerVault is a distributed, encrypted file storage system with peer-to-peer replication.",
-			"version":     "1.0.0",
-		},
-		"paths": map[string]interface{}{
-			"/health": map[string]interface{}{
-				"get": map[string]interface{}{
-					"summary":     "Health Check",
-					"description": "Check the health status of the API",
-					"responses": map[string]interface{}{
-						"200": map[string]interface{}{
-							"description": "Health status",
-							"content": map[string]interface{}{
-								"application/json": map[string]interface{}{
-									"schema": map[string]interface{}{
-										"$ref": "#/components/schemas/HealthResponse",
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-			"/metrics": map[string]interface{}{
-				"get": map[string]interface{}{
-					"summary":     "Get Metrics",
-					"description": "Get system metrics and performance data",
-					"responses": map[string]interface{}{
-						"200": map[string]interface{}{
-							"description": "System metrics",
-							"content": map[string]interface{}{
-								"application/json": map[string]interface{}{
-									"schema": map[string]interface{}{
-										"$ref": "#/components/schemas/MetricsResponse",
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-		},
-		"components": map[string]interface{}{
-			"schemas": map[string]interface{}{
-				"HealthResponse": map[string]interface{}{
-					"type": "object",
-					"properties": map[string]interface{}{
-						"status": map[string]interface{}{
-							"type": "string",
-						},
-						"timestamp": map[string]interface{}{
-							"type":   "string",
-							"format": "date-time",
-						},
-						"version": map[string]interface{}{
-							"type": "string",
-						},
-					},
-				},
-				"MetricsResponse": map[string]interface{}{
-					"type": "object",
-					"properties": map[string]interface{}{
-						"requests_total": map[string]interface{}{
-							"type": "integer",
-						},
-						"requests_per_minute": map[string]interface{}{
-							"type": "number",
-						},
-						"active_connections": map[string]interface{}{
-							"type": "integer",
-						},
-						"storage_usage_percent": map[string]interface{}{
-							"type": "number",
-						},
-						"last_updated": map[string]interface{}{
-							"type":   "string",
-							"format": "date-time",
-						},
-					},
-				},
-			},
-		},
+	if e.openAPI == nil {
+		http.Error(w, "API description unavailable", http.StatusServiceUnavailable)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(swaggerSpec); err != nil {
+	if err := json.NewEncoder(w).Encode(e.openAPI); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 		return
 	}
@@ -0,0 +1,36 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+)
+
+type TopologyEndpoints struct {
+	topologyService services.TopologyService
+	logger          *slog.Logger
+}
+
+func NewTopologyEndpoints(topologyService services.TopologyService, logger *slog.Logger) *TopologyEndpoints {
+	return &TopologyEndpoints{
+		topologyService: topologyService,
+		logger:          logger,
+	}
+}
+
+// HandleGetTopology handles GET /peers/topology
+func (e *TopologyEndpoints) HandleGetTopology(w http.ResponseWriter, r *http.Request) {
+	topology, err := e.topologyService.GetTopology(r.Context())
+	if err != nil {
+		e.logger.Error("Failed to get peer topology", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(topology); err != nil {
+		e.logger.Error("Failed to encode response", "error", err)
+	}
+}
@@ -0,0 +1,151 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/uploads"
+)
+
+// PartChecksumHeader carries the hex SHA-256 of an uploaded part
+const PartChecksumHeader = "X-Content-SHA256"
+
+type UploadEndpoints struct {
+	uploadService services.UploadService
+	logger        *slog.Logger
+}
+
+func NewUploadEndpoints(uploadService services.UploadService, logger *slog.Logger) *UploadEndpoints {
+	return &UploadEndpoints{
+		uploadService: uploadService,
+		logger:        logger,
+	}
+}
+
+// HandleCreateUpload handles POST /uploads
+func (e *UploadEndpoints) HandleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	var req requests.UploadCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	upload, err := e.uploadService.CreateUpload(r.Context(), &req)
+	if err != nil {
+		if errors.Is(err, uploads.ErrInvalidUpload) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		e.logger.Error("Failed to create upload", "name", req.Name, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, http.StatusCreated, toUploadResponse(upload))
+}
+
+// HandleGetUpload handles GET /uploads/{id}
+func (e *UploadEndpoints) HandleGetUpload(w http.ResponseWriter, r *http.Request) {
+	upload, err := e.uploadService.GetUpload(r.Context(), r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, toUploadResponse(upload))
+}
+
+// HandleUploadPart handles PUT /uploads/{id}/parts/{part}. The body is the
+// raw part; parts are numbered from 0 and may be sent in any order.
+func (e *UploadEndpoints) HandleUploadPart(w http.ResponseWriter, r *http.Request) {
+	part, err := strconv.Atoi(r.PathValue("part"))
+	if err != nil {
+		http.Error(w, "Invalid part number", http.StatusBadRequest)
+		return
+	}
+
+	sum, err := e.uploadService.UploadPart(r.Context(), r.PathValue("id"), part, r.Body, r.Header.Get(PartChecksumHeader))
+	if err != nil {
+		switch {
+		case errors.Is(err, uploads.ErrNotFound):
+			http.Error(w, "Upload not found", http.StatusNotFound)
+		case errors.Is(err, uploads.ErrInvalidPart), errors.Is(err, uploads.ErrPartSize), errors.Is(err, uploads.ErrChecksumMismatch):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			e.logger.Error("Failed to store upload part", "id", r.PathValue("id"), "part", part, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	upload, err := e.uploadService.GetUpload(r.Context(), r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	e.writeJSON(w, http.StatusOK, responses.UploadPartResponse{
+		Part:   part,
+		Size:   upload.PartLength(part),
+		SHA256: sum,
+	})
+}
+
+// HandleCompleteUpload handles POST /uploads/{id}/complete
+func (e *UploadEndpoints) HandleCompleteUpload(w http.ResponseWriter, r *http.Request) {
+	file, err := e.uploadService.CompleteUpload(r.Context(), r.PathValue("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, uploads.ErrNotFound):
+			http.Error(w, "Upload not found", http.StatusNotFound)
+		case errors.Is(err, uploads.ErrIncomplete):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			e.logger.Error("Failed to complete upload", "id", r.PathValue("id"), "error", err)
+			if !writeStoreError(w, err) {
+				http.Error(w, "Failed to upload file", http.StatusInternalServerError)
+			}
+		}
+		return
+	}
+	e.writeJSON(w, http.StatusCreated, types.FileToResponse(file))
+}
+
+// HandleAbortUpload handles DELETE /uploads/{id}
+func (e *UploadEndpoints) HandleAbortUpload(w http.ResponseWriter, r *http.Request) {
+	if err := e.uploadService.AbortUpload(r.Context(), r.PathValue("id")); err != nil {
+		if errors.Is(err, uploads.ErrNotFound) {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		}
+		e.logger.Error("Failed to abort upload", "id", r.PathValue("id"), "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func toUploadResponse(upload *uploads.Upload) responses.UploadResponse {
+	return responses.UploadResponse{
+		ID:        upload.ID,
+		Name:      upload.Name,
+		Size:      upload.Size,
+		PartSize:  upload.PartSize,
+		PartCount: upload.PartCount(),
+		Parts:     upload.Parts,
+		CreatedAt: upload.CreatedAt,
+		UpdatedAt: upload.UpdatedAt,
+	}
+}
+
+func (e *UploadEndpoints) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		e.logger.Error("Failed to encode upload response", "error", err)
+	}
+}
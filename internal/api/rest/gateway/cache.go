@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/types"
+	"github.com/Skpow1234/Peervault/internal/cache"
+)
+
+// Values of the X-Cache header telling how a download was served
+const (
+	cacheHit         = "HIT"
+	cacheMiss        = "MISS"
+	cacheRevalidated = "REVALIDATED"
+	cacheBypass      = "BYPASS"
+)
+
+// cachedFile is a public file kept by the gateway
+type cachedFile struct {
+	file types.File
+	data []byte
+	// validated is when the content was last known to be current
+	validated time.Time
+}
+
+// requestCacheControl is what the Cache-Control header of a request asks
+// of the gateway's cache
+type requestCacheControl struct {
+	// noStore bypasses the cache
+	noStore bool
+	// maxAge is the oldest response the client accepts; negative when the
+	// client does not say
+	maxAge time.Duration
+}
+
+func parseCacheControl(header string) requestCacheControl {
+	cc := requestCacheControl{maxAge: -1}
+	for directive := range strings.SplitSeq(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store":
+			cc.noStore = true
+		case "no-cache":
+			cc.maxAge = 0
+		case "max-age":
+			if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil && seconds >= 0 {
+				cc.maxAge = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return cc
+}
+
+// download returns a public file from the cache while it is fresh, and
+// from the source otherwise. A stale copy whose hash the source still has
+// is revalidated without downloading it again. It reports how the file
+// was served and how old the response is.
+func (g *Gateway) download(ctx context.Context, key string, header http.Header) (*types.File, []byte, string, time.Duration, error) {
+	if g.cache == nil {
+		file, data, err := g.source.DownloadFile(ctx, key)
+		return file, data, "", 0, err
+	}
+	cc := parseCacheControl(header.Get("Cache-Control"))
+	if cc.noStore {
+		file, data, err := g.source.DownloadFile(ctx, key)
+		return file, data, cacheBypass, 0, err
+	}
+
+	maxAge := g.config.CacheMaxAge
+	if cc.maxAge >= 0 {
+		maxAge = min(maxAge, cc.maxAge)
+	}
+	now := time.Now()
+	if cached, ok := g.cache.Get(key); ok {
+		if age := now.Sub(cached.validated); age < maxAge {
+			return &cached.file, cached.data, cacheHit, age, nil
+		}
+		if current, err := g.source.GetFile(ctx, key); err == nil && current.Hash != "" && current.Hash == cached.file.Hash {
+			g.cache.Put(key, &cachedFile{file: *current, data: cached.data, validated: now}, int64(len(cached.data)))
+			return current, cached.data, cacheRevalidated, 0, nil
+		}
+	}
+
+	file, data, err := g.source.DownloadFile(ctx, key)
+	if err != nil {
+		g.cache.Remove(key)
+		return nil, nil, cacheMiss, 0, err
+	}
+	g.cache.Put(key, &cachedFile{file: *file, data: data, validated: now}, int64(len(data)))
+	return file, data, cacheMiss, 0, nil
+}
+
+// CacheStats returns the counters of the gateway's cache; ok is false
+// when it has none
+func (g *Gateway) CacheStats() (stats cache.ByteCacheStats, ok bool) {
+	if g.cache == nil {
+		return stats, false
+	}
+	return g.cache.Stats(), true
+}
@@ -0,0 +1,299 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/ratelimit"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types"
+	"github.com/Skpow1234/Peervault/internal/api/rest/versioning"
+	"github.com/Skpow1234/Peervault/internal/cache"
+	"github.com/Skpow1234/Peervault/internal/clientip"
+	"github.com/Skpow1234/Peervault/internal/etag"
+)
+
+// PathPrefix is where the gateway serves public files
+const PathPrefix = "/public/"
+
+// Config holds the configuration of the public download gateway
+type Config struct {
+	Enabled bool
+	// Keys are served exactly; Prefixes match any key starting with them.
+	// Nothing is public unless listed.
+	Keys     []string
+	Prefixes []string
+
+	// RequestsPerMin and Burst limit requests per client IP
+	RequestsPerMin int
+	Burst          int
+	// BytesPerSecond throttles the download rate per client IP; 0 disables
+	BytesPerSecond int64
+	// QuotaBytes caps how much a client IP can download per QuotaWindow; 0 disables
+	QuotaBytes  int64
+	QuotaWindow time.Duration
+	// CacheMaxAge is advertised to browsers and proxies, and is how long
+	// the gateway's own cache serves a file before checking it changed
+	CacheMaxAge time.Duration
+	// CacheSize is how many bytes of public files the gateway keeps in
+	// memory; 0 disables its cache
+	CacheSize int64
+	// CachePolicy is the eviction policy of the cache, cache.PolicyLRU or
+	// cache.PolicyARC
+	CachePolicy string
+}
+
+// DefaultConfig returns a disabled gateway with conservative limits
+func DefaultConfig() *Config {
+	return &Config{
+		RequestsPerMin: 60,
+		Burst:          10,
+		BytesPerSecond: 1 << 20,
+		QuotaBytes:     1 << 30,
+		QuotaWindow:    24 * time.Hour,
+		CacheMaxAge:    5 * time.Minute,
+		CachePolicy:    cache.PolicyLRU,
+	}
+}
+
+// Source provides the content of public files
+type Source interface {
+	DownloadFile(ctx context.Context, key string) (*types.File, []byte, error)
+	// GetFile returns the description of a file, whose hash tells whether
+	// a cached copy is current
+	GetFile(ctx context.Context, key string) (*types.File, error)
+}
+
+// Gateway serves whitelisted files without authentication
+type Gateway struct {
+	config      *Config
+	source      Source
+	logger      *slog.Logger
+	rateLimiter *ratelimit.RateLimiter
+	// cache keeps hot public files; nil when disabled
+	cache *cache.ByteCache[*cachedFile]
+
+	mu      sync.Mutex
+	clients map[string]*clientBandwidth
+}
+
+// clientBandwidth tracks the transfer budget of a client IP
+type clientBandwidth struct {
+	tokens     float64
+	lastRefill time.Time
+
+	windowStart time.Time
+	windowBytes int64
+}
+
+// NewGateway creates a gateway serving files from source
+func NewGateway(config *Config, source Source, logger *slog.Logger) *Gateway {
+	var files *cache.ByteCache[*cachedFile]
+	if config.CacheSize > 0 {
+		var err error
+		if files, err = cache.NewByteCache[*cachedFile](config.CacheSize, config.CachePolicy, 0); err != nil {
+			logger.Error("Invalid gateway cache, serving without it", "error", err)
+		}
+	}
+	return &Gateway{
+		config: config,
+		source: source,
+		logger: logger,
+		rateLimiter: ratelimit.NewRateLimiter(&ratelimit.RateLimitConfig{
+			Algorithm:       ratelimit.TokenBucket,
+			RequestsPerMin:  config.RequestsPerMin,
+			BurstSize:       config.Burst,
+			CleanupInterval: 5 * time.Minute,
+			Enabled:         config.RequestsPerMin > 0,
+		}),
+		clients: make(map[string]*clientBandwidth),
+		cache:   files,
+	}
+}
+
+// Stop releases the gateway's background resources
+func (g *Gateway) Stop() {
+	g.rateLimiter.Stop()
+}
+
+// Allowed reports whether a key is whitelisted
+func (g *Gateway) Allowed(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, k := range g.config.Keys {
+		if key == k {
+			return true
+		}
+	}
+	for _, prefix := range g.config.Prefixes {
+		if prefix != "" && strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTP handles GET and HEAD /public/{key...}
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.PathValue("key")
+	if key == "" {
+		key = strings.TrimPrefix(r.URL.Path, PathPrefix)
+	}
+
+	if !g.rateLimiter.IsAllowed(r, versioning.Version_1_0_0) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	// Unlisted keys look exactly like missing ones so the gateway does not
+	// reveal what else is stored
+	if !g.Allowed(key) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	// The cache honors the request's Cache-Control: no-store bypasses it,
+	// and no-cache or max-age bound the age of what it serves
+	file, data, served, age, err := g.download(r.Context(), key, r.Header)
+	if served != "" {
+		w.Header().Set("X-Cache", served)
+	}
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if served == cacheHit {
+		w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+	}
+
+	ip := clientip.FromRequest(r)
+	if retry, ok := g.checkQuota(ip); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+		http.Error(w, "Download quota exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(g.config.CacheMaxAge.Seconds())))
+	if file.Hash != "" {
+		w.Header().Set("ETag", etag.Quote(file.Hash))
+	}
+
+	tw := &throttledWriter{ResponseWriter: w, gateway: g, ip: ip, ctx: r.Context()}
+	http.ServeContent(tw, r, file.Name, file.UpdatedAt, bytes.NewReader(data))
+	g.logger.Info("Gateway download", "key", key, "ip", ip, "bytes", tw.written)
+}
+
+// checkQuota reports whether ip may start another download and, if not,
+// how long until its quota window resets
+func (g *Gateway) checkQuota(ip string) (time.Duration, bool) {
+	if g.config.QuotaBytes <= 0 {
+		return 0, true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c := g.clientLocked(ip, time.Now())
+	if c.windowBytes < g.config.QuotaBytes {
+		return 0, true
+	}
+	return time.Until(c.windowStart.Add(g.config.QuotaWindow)), false
+}
+
+// reserve accounts n bytes sent to ip and returns how long the sender has
+// to wait to stay under the bandwidth cap
+func (g *Gateway) reserve(ip string, n int) time.Duration {
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c := g.clientLocked(ip, now)
+	c.windowBytes += int64(n)
+
+	rate := float64(g.config.BytesPerSecond)
+	if rate <= 0 {
+		return 0
+	}
+	// One second of transfer can be sent at full speed
+	c.tokens = min(rate, c.tokens+now.Sub(c.lastRefill).Seconds()*rate)
+	c.lastRefill = now
+	c.tokens -= float64(n)
+	if c.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-c.tokens / rate * float64(time.Second))
+}
+
+func (g *Gateway) clientLocked(ip string, now time.Time) *clientBandwidth {
+	c, ok := g.clients[ip]
+	if !ok {
+		// Forget idle clients so the map stays bounded by active ones
+		idle := max(g.config.QuotaWindow, time.Minute)
+		for key, other := range g.clients {
+			if now.Sub(other.lastRefill) > idle && now.Sub(other.windowStart) > idle {
+				delete(g.clients, key)
+			}
+		}
+		c = &clientBandwidth{tokens: float64(g.config.BytesPerSecond), lastRefill: now, windowStart: now}
+		g.clients[ip] = c
+	}
+	if g.config.QuotaWindow > 0 && now.Sub(c.windowStart) >= g.config.QuotaWindow {
+		c.windowStart = now
+		c.windowBytes = 0
+	}
+	return c
+}
+
+// throttledWriter paces writes to the per-IP bandwidth cap
+type throttledWriter struct {
+	http.ResponseWriter
+	gateway *Gateway
+	ip      string
+	ctx     context.Context
+	written int64
+}
+
+const throttleChunk = 32 << 10
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		n := min(len(p), throttleChunk)
+		if wait := tw.gateway.reserve(tw.ip, n); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-tw.ctx.Done():
+				timer.Stop()
+				return total, tw.ctx.Err()
+			case <-timer.C:
+			}
+		}
+		written, err := tw.ResponseWriter.Write(p[:n])
+		total += written
+		tw.written += int64(written)
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
@@ -0,0 +1,223 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/types"
+)
+
+type memorySource map[string][]byte
+
+func (m memorySource) DownloadFile(ctx context.Context, key string) (*types.File, []byte, error) {
+	data, ok := m[key]
+	if !ok {
+		return nil, nil, errors.New("file not found")
+	}
+	return &types.File{Key: key, Name: key, Size: int64(len(data)), ContentType: "text/plain", Hash: "h-" + key}, data, nil
+}
+
+func (m memorySource) GetFile(ctx context.Context, key string) (*types.File, error) {
+	file, _, err := m.DownloadFile(ctx, key)
+	return file, err
+}
+
+// versionedSource serves one file whose hash changes with each version and
+// counts how often it is asked for it
+type versionedSource struct {
+	version   int
+	downloads int
+	lookups   int
+}
+
+func (v *versionedSource) file() *types.File {
+	return &types.File{Key: "logo.png", Name: "logo.png", Hash: fmt.Sprintf("v%d", v.version)}
+}
+
+func (v *versionedSource) DownloadFile(ctx context.Context, key string) (*types.File, []byte, error) {
+	v.downloads++
+	return v.file(), []byte(fmt.Sprintf("content v%d", v.version)), nil
+}
+
+func (v *versionedSource) GetFile(ctx context.Context, key string) (*types.File, error) {
+	v.lookups++
+	return v.file(), nil
+}
+
+func newTestGateway(config *Config) *Gateway {
+	source := memorySource{
+		"logo.png":          []byte("png-bytes"),
+		"public/docs/a.txt": []byte("document a"),
+		"private.txt":       []byte("secret"),
+	}
+	return NewGateway(config, source, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func get(g *Gateway, key, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", PathPrefix+key, nil)
+	req.SetPathValue("key", key)
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, req)
+	return w
+}
+
+func TestGatewayWhitelist(t *testing.T) {
+	config := DefaultConfig()
+	config.Keys = []string{"logo.png"}
+	config.Prefixes = []string{"public/docs/"}
+	g := newTestGateway(config)
+	defer g.Stop()
+
+	w := get(g, "logo.png", "192.0.2.1:1000")
+	if w.Code != http.StatusOK || w.Body.String() != "png-bytes" {
+		t.Fatalf("Expected whitelisted key to be served, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("ETag") != `"h-logo.png"` || w.Header().Get("Cache-Control") == "" {
+		t.Errorf("Expected caching headers, got %v", w.Header())
+	}
+
+	if w := get(g, "public/docs/a.txt", "192.0.2.1:1000"); w.Code != http.StatusOK {
+		t.Errorf("Expected prefixed key to be served, got %d", w.Code)
+	}
+	if w := get(g, "private.txt", "192.0.2.1:1000"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected unlisted key to be hidden, got %d", w.Code)
+	}
+	if w := get(g, "public/docs/missing.txt", "192.0.2.1:1000"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected missing key to return 404, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("DELETE", PathPrefix+"logo.png", nil)
+	req.SetPathValue("key", "logo.png")
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected gateway to be read-only, got %d", w.Code)
+	}
+}
+
+func TestGatewayRateLimitPerIP(t *testing.T) {
+	config := DefaultConfig()
+	config.Keys = []string{"logo.png"}
+	config.RequestsPerMin = 1
+	config.Burst = 2
+	g := newTestGateway(config)
+	defer g.Stop()
+
+	for i := 0; i < 2; i++ {
+		if w := get(g, "logo.png", "192.0.2.1:1000"); w.Code != http.StatusOK {
+			t.Fatalf("Request %d should be allowed, got %d", i+1, w.Code)
+		}
+	}
+	if w := get(g, "logo.png", "192.0.2.1:1000"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected rate limit, got %d", w.Code)
+	}
+	// Other clients are unaffected
+	if w := get(g, "logo.png", "198.51.100.9:1000"); w.Code != http.StatusOK {
+		t.Errorf("Expected other IP to be allowed, got %d", w.Code)
+	}
+}
+
+func TestGatewayQuota(t *testing.T) {
+	config := DefaultConfig()
+	config.Keys = []string{"logo.png"}
+	config.QuotaBytes = 10
+	config.QuotaWindow = time.Hour
+	g := newTestGateway(config)
+	defer g.Stop()
+
+	// 9 bytes per download: the second starts under quota, the third does not
+	for i := 0; i < 2; i++ {
+		if w := get(g, "logo.png", "192.0.2.1:1000"); w.Code != http.StatusOK {
+			t.Fatalf("Download %d should be allowed, got %d", i+1, w.Code)
+		}
+	}
+	w := get(g, "logo.png", "192.0.2.1:1000")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected quota to be enforced, got %d", w.Code)
+	}
+}
+
+func TestGatewayBandwidthThrottle(t *testing.T) {
+	config := DefaultConfig()
+	config.Prefixes = []string{"big"}
+	config.BytesPerSecond = 64 << 10
+	source := memorySource{"big": bytes.Repeat([]byte("x"), 96<<10)}
+	g := NewGateway(config, source, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer g.Stop()
+
+	// The first second's worth is sent at once, the rest is paced
+	start := time.Now()
+	w := get(g, "big", "192.0.2.1:1000")
+	elapsed := time.Since(start)
+	if w.Code != http.StatusOK || w.Body.Len() != 96<<10 {
+		t.Fatalf("Expected full download, got %d with %d bytes", w.Code, w.Body.Len())
+	}
+	if elapsed < 400*time.Millisecond {
+		t.Errorf("Expected download to be throttled, took %v", elapsed)
+	}
+}
+
+func TestGatewayCache(t *testing.T) {
+	config := DefaultConfig()
+	config.Keys = []string{"logo.png"}
+	config.RequestsPerMin = 0
+	config.CacheSize = 1 << 20
+	config.CacheMaxAge = time.Hour
+	source := &versionedSource{version: 1}
+	g := NewGateway(config, source, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer g.Stop()
+
+	fetch := func(cacheControl string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", PathPrefix+"logo.png", nil)
+		req.SetPathValue("key", "logo.png")
+		if cacheControl != "" {
+			req.Header.Set("Cache-Control", cacheControl)
+		}
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := fetch(""); w.Header().Get("X-Cache") != cacheMiss {
+		t.Fatalf("Expected first download to miss, got %q", w.Header().Get("X-Cache"))
+	}
+	w := fetch("")
+	if w.Header().Get("X-Cache") != cacheHit || w.Header().Get("Age") == "" || w.Body.String() != "content v1" {
+		t.Fatalf("Expected a cache hit, got %q: %s", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if source.downloads != 1 {
+		t.Errorf("Expected one download from the source, got %d", source.downloads)
+	}
+
+	// no-cache makes the gateway check the file is unchanged
+	if w := fetch("no-cache"); w.Header().Get("X-Cache") != cacheRevalidated || source.downloads != 1 || source.lookups != 1 {
+		t.Errorf("Expected revalidation without download, got %q", w.Header().Get("X-Cache"))
+	}
+
+	// A changed hash is downloaded again
+	source.version = 2
+	w = fetch("max-age=0")
+	if w.Header().Get("X-Cache") != cacheMiss || w.Body.String() != "content v2" || w.Header().Get("ETag") != `"v2"` {
+		t.Errorf("Expected the new version, got %q: %s", w.Header().Get("X-Cache"), w.Body.String())
+	}
+
+	// no-store skips the cache entirely
+	if w := fetch("no-store"); w.Header().Get("X-Cache") != cacheBypass || source.downloads != 3 {
+		t.Errorf("Expected the cache to be bypassed, got %q", w.Header().Get("X-Cache"))
+	}
+
+	// The cache counts lookups finding an entry, fresh or stale
+	stats, ok := g.CacheStats()
+	if !ok || stats.Hits != 3 || stats.Misses != 1 {
+		t.Errorf("Unexpected cache stats: %+v", stats)
+	}
+}
@@ -0,0 +1,101 @@
+package implementations
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/alerting"
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+)
+
+type AlertServiceImpl struct {
+	server *fileserver.Server
+}
+
+func NewAlertService(server *fileserver.Server) services.AlertService {
+	return &AlertServiceImpl{server: server}
+}
+
+func (s *AlertServiceImpl) ListAlerts(ctx context.Context) []alerting.Alert {
+	return s.server.Alerts.Alerts()
+}
+
+func (s *AlertServiceImpl) ListRules(ctx context.Context) []alerting.Rule {
+	return s.server.Alerts.Rules()
+}
+
+func (s *AlertServiceImpl) PutRule(ctx context.Context, name string, req *requests.AlertRuleRequest) (alerting.Rule, error) {
+	return s.server.Alerts.PutRule(alerting.Rule{
+		Name:      name,
+		Metric:    req.Metric,
+		Kind:      req.Kind,
+		Op:        req.Op,
+		Threshold: req.Threshold,
+		Window:    req.Window,
+		For:       req.For,
+		Severity:  req.Severity,
+		Summary:   req.Summary,
+		Channels:  req.Channels,
+	})
+}
+
+func (s *AlertServiceImpl) DeleteRule(ctx context.Context, name string) error {
+	return s.server.Alerts.DeleteRule(name)
+}
+
+func (s *AlertServiceImpl) ListChannels(ctx context.Context) []alerting.Channel {
+	return s.server.Alerts.Channels()
+}
+
+func (s *AlertServiceImpl) PutChannel(ctx context.Context, name string, req *requests.AlertChannelRequest) (alerting.Channel, error) {
+	return s.server.Alerts.PutChannel(alerting.Channel{Name: name, Type: req.Type, URL: req.URL, To: req.To})
+}
+
+func (s *AlertServiceImpl) DeleteChannel(ctx context.Context, name string) error {
+	return s.server.Alerts.DeleteChannel(name)
+}
+
+func (s *AlertServiceImpl) ListSilences(ctx context.Context) []alerting.Silence {
+	return s.server.Alerts.Silences()
+}
+
+func (s *AlertServiceImpl) AddSilence(ctx context.Context, req *requests.SilenceRequest) (alerting.Silence, error) {
+	endsAt := req.EndsAt
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			return alerting.Silence{}, fmt.Errorf("%w: duration must be positive, such as 2h", alerting.ErrInvalid)
+		}
+		start := req.StartsAt
+		if start.IsZero() {
+			start = time.Now()
+		}
+		endsAt = start.Add(d)
+	}
+	return s.server.Alerts.AddSilence(alerting.Silence{
+		Rule:      req.Rule,
+		StartsAt:  req.StartsAt,
+		EndsAt:    endsAt,
+		Comment:   req.Comment,
+		CreatedBy: req.CreatedBy,
+	})
+}
+
+func (s *AlertServiceImpl) DeleteSilence(ctx context.Context, id string) error {
+	return s.server.Alerts.DeleteSilence(id)
+}
+
+func (s *AlertServiceImpl) ListMetrics(ctx context.Context) []alerting.Sample {
+	return s.server.Alerts.Samples()
+}
+
+func (s *AlertServiceImpl) PushMetrics(ctx context.Context, req *requests.MetricsRequest) error {
+	if len(req.Metrics) == 0 {
+		return fmt.Errorf("%w: no metrics", alerting.ErrInvalid)
+	}
+	s.server.Alerts.Observe(req.Metrics, time.Now())
+	return nil
+}
@@ -0,0 +1,28 @@
+package implementations
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/analytics"
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+)
+
+type AnalyticsServiceImpl struct {
+	collector *analytics.Collector
+}
+
+func NewAnalyticsService(collector *analytics.Collector) services.AnalyticsService {
+	return &AnalyticsServiceImpl{collector: collector}
+}
+
+func (s *AnalyticsServiceImpl) GetRollups(ctx context.Context, q analytics.Query) ([]analytics.Rollup, error) {
+	return s.collector.Query(q)
+}
+
+func (s *AnalyticsServiceImpl) GetTop(ctx context.Context, q analytics.Query, limit int) ([]analytics.Total, error) {
+	return s.collector.Top(q, limit)
+}
+
+func (s *AnalyticsServiceImpl) BucketSeconds() int64 {
+	return int64(s.collector.BucketSize().Seconds())
+}
@@ -0,0 +1,165 @@
+package implementations
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/backup"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+	"github.com/Skpow1234/Peervault/internal/retention"
+	"github.com/Skpow1234/Peervault/internal/search"
+	"github.com/Skpow1234/Peervault/pkg/merkle"
+)
+
+type BackupServiceImpl struct {
+	scheduler *backup.Scheduler
+}
+
+func NewBackupService(scheduler *backup.Scheduler) services.BackupService {
+	return &BackupServiceImpl{scheduler: scheduler}
+}
+
+// NewBackupEngine creates a backup engine over the files of a file
+// service. Restores honour retention locks like any other overwrite.
+func NewBackupEngine(files services.FileService, logger *slog.Logger) (*backup.Engine, error) {
+	impl, ok := files.(*FileServiceImpl)
+	if !ok {
+		return nil, errors.New("backups require the metadata-backed file service")
+	}
+	store := &fileBackupStore{files: impl}
+	return backup.NewEngine(store, store, logger), nil
+}
+
+func (s *BackupServiceImpl) ListJobs(ctx context.Context) ([]backup.JobStatus, error) {
+	return s.scheduler.Jobs(), nil
+}
+
+func (s *BackupServiceImpl) RunBackup(ctx context.Context, job string, typ backup.BackupType) error {
+	return s.scheduler.Trigger(job, typ)
+}
+
+func (s *BackupServiceImpl) ListSnapshots(ctx context.Context, job string) ([]backup.Snapshot, error) {
+	j, err := s.scheduler.Job(job)
+	if err != nil {
+		return nil, err
+	}
+	return s.scheduler.Engine().Snapshots(ctx, j)
+}
+
+func (s *BackupServiceImpl) GetSnapshot(ctx context.Context, job, id string) (*backup.Snapshot, error) {
+	j, err := s.scheduler.Job(job)
+	if err != nil {
+		return nil, err
+	}
+	return s.scheduler.Engine().Snapshot(ctx, j, id)
+}
+
+func (s *BackupServiceImpl) VerifySnapshot(ctx context.Context, job, id string) (*backup.VerifyReport, error) {
+	j, err := s.scheduler.Job(job)
+	if err != nil {
+		return nil, err
+	}
+	return s.scheduler.Engine().Verify(ctx, j, id)
+}
+
+func (s *BackupServiceImpl) DeleteSnapshot(ctx context.Context, job, id string) error {
+	j, err := s.scheduler.Job(job)
+	if err != nil {
+		return err
+	}
+	return s.scheduler.Engine().Delete(ctx, j, id)
+}
+
+func (s *BackupServiceImpl) Restore(ctx context.Context, job string, opts backup.RestoreOptions) (*backup.RestoreReport, error) {
+	j, err := s.scheduler.Job(job)
+	if err != nil {
+		return nil, err
+	}
+	return s.scheduler.Engine().Restore(ctx, j, opts)
+}
+
+// fileBackupStore adapts FileServiceImpl to the backup engine
+type fileBackupStore struct {
+	files *FileServiceImpl
+}
+
+func (s *fileBackupStore) List(ctx context.Context, prefix string) ([]backup.Entry, error) {
+	var entries []backup.Entry
+	for _, rec := range s.files.metadata.List() {
+		// Records without content (e.g. seeded examples) have nothing to back up
+		if !strings.HasPrefix(rec.Key, prefix) || !s.files.content.Has(rec.Key) {
+			continue
+		}
+		entries = append(entries, backup.Entry{
+			Key:         rec.Key,
+			Name:        rec.Name,
+			Size:        rec.Size,
+			ContentType: rec.ContentType,
+			Hash:        rec.Hash,
+			Tags:        rec.Tags,
+			Metadata:    rec.Metadata,
+			ModTime:     rec.UpdatedAt,
+		})
+	}
+	return entries, nil
+}
+
+func (s *fileBackupStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	_, data, err := s.files.DownloadFile(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *fileBackupStore) Restore(ctx context.Context, entry backup.Entry, r io.Reader, overwrite bool) error {
+	if _, err := s.files.metadata.Get(entry.Key); err == nil {
+		if !overwrite {
+			return backup.ErrExists
+		}
+		if s.files.locks != nil {
+			if err := s.files.locks.Check(ctx, entry.Key, retention.OpOverwrite, "backup"); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Read everything first so a blob failing its checksum writes nothing
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	// Content first, as with uploads, so the record never lacks it
+	report, err := s.files.content.Put(ctx, entry.Key, data)
+	if err != nil {
+		return err
+	}
+	if report != nil {
+		entry.Tags, entry.Metadata = report.Apply(entry.Tags, entry.Metadata)
+	}
+	if _, err := s.files.metadata.Put(metadata.FileRecord{
+		Key:         entry.Key,
+		Name:        entry.Name,
+		Size:        int64(len(data)),
+		ContentType: entry.ContentType,
+		Hash:        entry.Hash,
+		MerkleRoot:  merkle.RootOf(data),
+		Tags:        entry.Tags,
+		Metadata:    entry.Metadata,
+	}); err != nil {
+		return err
+	}
+
+	if s.files.search != nil {
+		err := s.files.search.IndexContent(entry.Key, entry.Name, entry.ContentType, bytes.NewReader(data))
+		if err != nil && !errors.Is(err, search.ErrUnsupportedFormat) {
+			slog.Warn("failed to index restored file", "key", entry.Key, "error", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package implementations
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/batch"
+)
+
+type BatchServiceImpl struct {
+	files services.FileService
+	// client fetches the content of stores by URL
+	client *http.Client
+}
+
+func NewBatchService(files services.FileService) services.BatchService {
+	return &BatchServiceImpl{files: files, client: &http.Client{}}
+}
+
+func (s *BatchServiceImpl) Execute(ctx context.Context, ops []batch.Operation, parallelism int) []batch.Outcome {
+	return batch.Run(ctx, ops, parallelism, s.execute)
+}
+
+func (s *BatchServiceImpl) execute(ctx context.Context, op batch.Operation) (any, error) {
+	switch op.Op {
+	case batch.OpStore:
+		data, err := op.Fetch(ctx, s.client)
+		if err != nil {
+			return nil, err
+		}
+		return s.files.UploadFileAt(ctx, op.Key, "", data, op.ContentType, op.Metadata, op.Tags)
+	case batch.OpDelete:
+		return nil, s.files.DeleteFile(ctx, op.Key)
+	case batch.OpCopy:
+		return s.files.CopyObject(ctx, &requests.FileCopyRequest{
+			From:      op.From,
+			To:        op.To,
+			Tenant:    op.Tenant,
+			Overwrite: op.Overwrite,
+			Metadata:  op.Metadata,
+		})
+	case batch.OpTag:
+		return s.files.PatchFileMetadata(ctx, op.Key, &requests.FileMetadataPatchRequest{
+			Set:        op.Set,
+			Remove:     op.Remove,
+			AddTags:    op.AddTags,
+			RemoveTags: op.RemoveTags,
+		})
+	}
+	return nil, fmt.Errorf("%w: unknown op %q", batch.ErrInvalid, op.Op)
+}
@@ -0,0 +1,41 @@
+package implementations
+
+import (
+	"context"
+	"io"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+)
+
+type ConflictServiceImpl struct {
+	server *fileserver.Server
+}
+
+func NewConflictService(server *fileserver.Server) services.ConflictService {
+	return &ConflictServiceImpl{server: server}
+}
+
+func (s *ConflictServiceImpl) ListConflicts(ctx context.Context) []fileserver.FileVersions {
+	return s.server.Conflicts()
+}
+
+func (s *ConflictServiceImpl) GetVersions(ctx context.Context, key string) (fileserver.FileVersions, error) {
+	return s.server.Versions(key)
+}
+
+func (s *ConflictServiceImpl) GetVersionContent(ctx context.Context, key, id string) ([]byte, error) {
+	r, err := s.server.ReadVersion(key, id)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func (s *ConflictServiceImpl) PickVersion(ctx context.Context, key, id string) (fileserver.FileVersion, error) {
+	return s.server.ResolveConflict(ctx, key, id)
+}
+
+func (s *ConflictServiceImpl) MergeVersions(ctx context.Context, key string, content io.Reader) (fileserver.FileVersion, error) {
+	return s.server.MergeConflict(ctx, key, content)
+}
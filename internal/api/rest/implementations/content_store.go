@@ -0,0 +1,179 @@
+package implementations
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/scan"
+)
+
+// contentStore holds file bytes; records live in the metadata store. Put
+// returns the report of the content scanner, nil when nothing scans. Copy
+// and Rename duplicate or move content to another key, replacing what is
+// stored there, without it passing through the client again.
+type contentStore interface {
+	Has(key string) bool
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, data []byte) (*scan.Report, error)
+	Delete(ctx context.Context, key string) error
+	Copy(ctx context.Context, from, to string) error
+	Rename(ctx context.Context, from, to string) error
+}
+
+// puller is implemented by content stores of edge nodes, which download
+// files they have no record of from an upstream cluster
+type puller interface {
+	Pulls(key string) bool
+	Pull(ctx context.Context, key string) ([]byte, error)
+}
+
+// attributeReplicator is implemented by content stores of nodes, which
+// send the tags and metadata of a file to the other nodes holding it
+type attributeReplicator interface {
+	ReplicateAttributes(key string) error
+}
+
+// memoryContent keeps content in memory for standalone API servers
+type memoryContent struct {
+	mu       sync.RWMutex
+	contents map[string][]byte
+}
+
+func newMemoryContent() *memoryContent {
+	return &memoryContent{contents: make(map[string][]byte)}
+}
+
+func (m *memoryContent) Has(key string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.contents[key]
+	return ok
+}
+
+func (m *memoryContent) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.contents[key]
+	if !ok {
+		return nil, fmt.Errorf("file content not available: %s", key)
+	}
+	return data, nil
+}
+
+func (m *memoryContent) Put(ctx context.Context, key string, data []byte) (*scan.Report, error) {
+	m.mu.Lock()
+	m.contents[key] = bytes.Clone(data)
+	m.mu.Unlock()
+	return nil, nil
+}
+
+func (m *memoryContent) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	delete(m.contents, key)
+	m.mu.Unlock()
+	return nil
+}
+
+// Copy shares the bytes of from, which are never modified in place
+func (m *memoryContent) Copy(ctx context.Context, from, to string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.contents[from]
+	if !ok {
+		return fmt.Errorf("file content not available: %s", from)
+	}
+	m.contents[to] = data
+	return nil
+}
+
+func (m *memoryContent) Rename(ctx context.Context, from, to string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.contents[from]
+	if !ok {
+		return fmt.Errorf("file content not available: %s", from)
+	}
+	m.contents[to] = data
+	delete(m.contents, from)
+	return nil
+}
+
+// nodeContent stores content on a PeerVault node, encrypted at rest and
+// shared with every other API mounted on the same node
+type nodeContent struct {
+	server *fileserver.Server
+}
+
+func (n *nodeContent) Has(key string) bool {
+	return n.server.Storage().Has(key)
+}
+
+func (n *nodeContent) Get(ctx context.Context, key string) ([]byte, error) {
+	// Checked first so a missing file isn't requested from every peer
+	if !n.Has(key) {
+		return nil, fmt.Errorf("file content not available: %s", key)
+	}
+	r, err := n.server.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// Pulls reports whether key is pulled from the origin of an edge node,
+// so it can be downloaded without a record here
+func (n *nodeContent) Pulls(key string) bool {
+	return n.server.Edge != nil && n.server.Edge.Pulls(key)
+}
+
+// Pull returns the content of key, from the node's copy or the origin
+func (n *nodeContent) Pull(ctx context.Context, key string) ([]byte, error) {
+	r, err := n.server.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func (n *nodeContent) Put(ctx context.Context, key string, data []byte) (*scan.Report, error) {
+	return n.server.StoreScanned(ctx, key, bytes.NewReader(data), nil, nil)
+}
+
+// ReplicateAttributes sends the record of key in the node's metadata store
+// to the other owners of the file
+func (n *nodeContent) ReplicateAttributes(key string) error {
+	return n.server.ReplicateAttributes(key)
+}
+
+func (n *nodeContent) Delete(ctx context.Context, key string) error {
+	return n.server.Delete(ctx, key)
+}
+
+// Copy stores the content again within the node, which stores and places
+// files by the hash of their key
+func (n *nodeContent) Copy(ctx context.Context, from, to string) error {
+	data, err := n.Get(ctx, from)
+	if err != nil {
+		return err
+	}
+	// The node does not overwrite stored files
+	if n.Has(to) {
+		if err := n.Delete(ctx, to); err != nil {
+			return err
+		}
+	}
+	_, err = n.Put(ctx, to, data)
+	return err
+}
+
+// Rename copies the content within the node and deletes the original
+func (n *nodeContent) Rename(ctx context.Context, from, to string) error {
+	if err := n.Copy(ctx, from, to); err != nil {
+		return err
+	}
+	return n.Delete(ctx, from)
+}
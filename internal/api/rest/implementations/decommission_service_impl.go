@@ -0,0 +1,28 @@
+package implementations
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+)
+
+type DecommissionServiceImpl struct {
+	server *fileserver.Server
+}
+
+func NewDecommissionService(server *fileserver.Server) services.DecommissionService {
+	return &DecommissionServiceImpl{server: server}
+}
+
+func (s *DecommissionServiceImpl) Status(ctx context.Context) fileserver.DecommissionStatus {
+	return s.server.DecommissionStatus()
+}
+
+func (s *DecommissionServiceImpl) Start(ctx context.Context) (fileserver.DecommissionStatus, error) {
+	return s.server.Decommission()
+}
+
+func (s *DecommissionServiceImpl) Abort(ctx context.Context) (fileserver.DecommissionStatus, error) {
+	return s.server.AbortDecommission()
+}
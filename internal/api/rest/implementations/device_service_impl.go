@@ -0,0 +1,63 @@
+package implementations
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/devices"
+)
+
+type DeviceServiceImpl struct {
+	ca *devices.CA
+}
+
+func NewDeviceService(ca *devices.CA) services.DeviceService {
+	return &DeviceServiceImpl{ca: ca}
+}
+
+func (s *DeviceServiceImpl) ListDevices(ctx context.Context) []devices.Device {
+	return s.ca.Devices()
+}
+
+func (s *DeviceServiceImpl) GetDevice(ctx context.Context, id string) (devices.Device, error) {
+	return s.ca.Device(id)
+}
+
+func (s *DeviceServiceImpl) RegisterDevice(ctx context.Context, req *requests.DeviceRequest, actor string) (devices.Device, string, error) {
+	return s.ca.Register(ctx, req.ID, req.Tenant, req.Comment, actor)
+}
+
+func (s *DeviceServiceImpl) ResetSecret(ctx context.Context, id, actor string) (devices.Device, string, error) {
+	return s.ca.ResetSecret(ctx, id, actor)
+}
+
+func (s *DeviceServiceImpl) RevokeDevice(ctx context.Context, id, actor string) error {
+	return s.ca.Revoke(ctx, id, actor)
+}
+
+func (s *DeviceServiceImpl) RevokeCertificate(ctx context.Context, id, serial, actor string) error {
+	return s.ca.RevokeCertificate(ctx, id, serial, actor)
+}
+
+func (s *DeviceServiceImpl) CACertificates(ctx context.Context) ([]byte, error) {
+	return devices.CertsOnly(s.ca.Certificate())
+}
+
+func (s *DeviceServiceImpl) CRL(ctx context.Context) ([]byte, error) {
+	return s.ca.CRL()
+}
+
+func (s *DeviceServiceImpl) Enroll(ctx context.Context, csr []byte, secret string, renew bool, addr string) ([]byte, error) {
+	request, err := x509.ParseCertificateRequest(csr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", devices.ErrInvalid, err)
+	}
+	cert, err := s.ca.Enroll(ctx, devices.EnrollRequest{CSR: request, Secret: secret, Renew: renew, Addr: addr})
+	if err != nil {
+		return nil, err
+	}
+	return devices.CertsOnly(cert)
+}
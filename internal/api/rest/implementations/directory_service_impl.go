@@ -0,0 +1,97 @@
+package implementations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+)
+
+type DirectoryServiceImpl struct {
+	tree *directory.Tree
+}
+
+// NewDirectoryService creates the folder view of the files of a file
+// service
+func NewDirectoryService(files services.FileService) (services.DirectoryService, error) {
+	impl, ok := files.(*FileServiceImpl)
+	if !ok {
+		return nil, errors.New("directories require the metadata-backed file service")
+	}
+	return &DirectoryServiceImpl{tree: directory.New(&fileDirectoryStore{files: impl})}, nil
+}
+
+func (s *DirectoryServiceImpl) ListDirectory(ctx context.Context, path string, recursive bool) (*directory.Listing, error) {
+	return s.tree.List(ctx, path, recursive)
+}
+
+func (s *DirectoryServiceImpl) MakeDirectory(ctx context.Context, path string) (*directory.Directory, error) {
+	return s.tree.Mkdir(ctx, path)
+}
+
+func (s *DirectoryServiceImpl) DeleteDirectory(ctx context.Context, path string, recursive bool) (*directory.Report, error) {
+	return s.tree.Delete(ctx, path, recursive)
+}
+
+func (s *DirectoryServiceImpl) Copy(ctx context.Context, from, to string, overwrite bool) (*directory.Report, error) {
+	return s.tree.Copy(ctx, from, to, overwrite)
+}
+
+func (s *DirectoryServiceImpl) Move(ctx context.Context, from, to string, overwrite bool) (*directory.Report, error) {
+	return s.tree.Move(ctx, from, to, overwrite)
+}
+
+// fileDirectoryStore adapts FileServiceImpl to directory.Store. Directory
+// objects are metadata records without content.
+type fileDirectoryStore struct {
+	files *FileServiceImpl
+}
+
+func (s *fileDirectoryStore) List(ctx context.Context, prefix string) ([]directory.Object, error) {
+	var objects []directory.Object
+	for _, rec := range s.files.metadata.List() {
+		if !strings.HasPrefix(rec.Key, prefix) {
+			continue
+		}
+		objects = append(objects, directory.Object{
+			Key:         rec.Key,
+			Name:        rec.Name,
+			Size:        rec.Size,
+			ContentType: rec.ContentType,
+			ModTime:     rec.UpdatedAt,
+		})
+	}
+	return objects, nil
+}
+
+func (s *fileDirectoryStore) Copy(ctx context.Context, from, to string, overwrite bool) error {
+	return s.files.copyFile(ctx, from, to, overwrite, nil)
+}
+
+func (s *fileDirectoryStore) Rename(ctx context.Context, from, to string, overwrite bool) error {
+	return s.files.renameFile(ctx, from, to, overwrite, nil)
+}
+
+func (s *fileDirectoryStore) Delete(ctx context.Context, key string) error {
+	if !strings.HasSuffix(key, directory.Separator) {
+		return s.files.DeleteFile(ctx, key)
+	}
+	_, err := s.files.metadata.Delete(key)
+	return err
+}
+
+func (s *fileDirectoryStore) Mkdir(ctx context.Context, key string) error {
+	if _, err := s.files.metadata.Get(key); err == nil {
+		return fmt.Errorf("%w: %s", directory.ErrExists, key)
+	}
+	_, err := s.files.metadata.Put(metadata.FileRecord{
+		Key:         key,
+		Name:        directory.Base(key),
+		ContentType: directory.ContentType,
+	})
+	return err
+}
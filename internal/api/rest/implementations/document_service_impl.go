@@ -0,0 +1,30 @@
+package implementations
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/crdt"
+)
+
+type DocumentServiceImpl struct {
+	server *fileserver.Server
+}
+
+func NewDocumentService(server *fileserver.Server) services.DocumentService {
+	return &DocumentServiceImpl{server: server}
+}
+
+func (s *DocumentServiceImpl) ListDocuments(ctx context.Context) []string {
+	return s.server.Documents()
+}
+
+func (s *DocumentServiceImpl) GetDocument(ctx context.Context, name string) (crdt.View, error) {
+	return s.server.Document(name)
+}
+
+func (s *DocumentServiceImpl) UpdateDocument(ctx context.Context, name string, req *requests.DocumentUpdateRequest) (crdt.View, error) {
+	return s.server.UpdateDocument(name, crdt.Update{Set: req.Set, Delete: req.Delete, Add: req.Add, Remove: req.Remove})
+}
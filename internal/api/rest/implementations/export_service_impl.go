@@ -0,0 +1,25 @@
+package implementations
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/export"
+)
+
+type ExportServiceImpl struct {
+	server *fileserver.Server
+}
+
+func NewExportService(server *fileserver.Server) services.ExportService {
+	return &ExportServiceImpl{server: server}
+}
+
+func (s *ExportServiceImpl) ListExports(ctx context.Context) []export.Info {
+	return s.server.Exports.List()
+}
+
+func (s *ExportServiceImpl) RunExport(ctx context.Context, name string) (*export.Run, error) {
+	return s.server.RunExport(ctx, name)
+}
@@ -1,120 +1,612 @@
 package implementations
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Skpow1234/Peervault/internal/api/rest/services"
 	"github.com/Skpow1234/Peervault/internal/api/rest/types"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"github.com/Skpow1234/Peervault/internal/etag"
+	"github.com/Skpow1234/Peervault/internal/media"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+	"github.com/Skpow1234/Peervault/internal/policy"
+	"github.com/Skpow1234/Peervault/internal/retention"
+	"github.com/Skpow1234/Peervault/internal/search"
+	"github.com/Skpow1234/Peervault/pkg/merkle"
 )
 
 type FileServiceImpl struct {
-	// TODO: Add fileserver dependency
-	// server *fileserver.Server
+	metadata *metadata.Store
+	search   *search.Index
+	locks    *retention.Manager
+	// content holds uploaded bytes, in memory or on a PeerVault node
+	content contentStore
+	// policy checks copies and moves; nil without a policy
+	policy *policy.Engine
+	// media probes stored images and videos and makes their thumbnails;
+	// nil without thumbnails
+	media *media.Pipeline
+	// conditional makes checking the condition of a conditional write and
+	// the write one step
+	conditional sync.Mutex
 }
 
 func NewFileService() services.FileService {
-	return &FileServiceImpl{}
+	return NewFileServiceWithSearch(nil)
+}
+
+// NewFileServiceWithSearch creates a file service that indexes the text of
+// uploaded documents; index may be nil when search is disabled
+func NewFileServiceWithSearch(index *search.Index) services.FileService {
+	return NewFileServiceWithRetention(index, nil)
+}
+
+// NewFileServiceWithRetention creates a file service that refuses to delete
+// files under retention or legal hold
+func NewFileServiceWithRetention(index *search.Index, locks *retention.Manager) services.FileService {
+	return &FileServiceImpl{metadata: metadata.NewStore(metadata.StoreOpts{}), search: index, locks: locks, content: newMemoryContent()}
+}
+
+// NewFileServiceWithFileServer creates a file service that keeps content on
+// the given node, so files uploaded here are the same files the node's
+// other APIs and peers see. Records live in the node's metadata store, or
+// one of the service's own when the node has none.
+func NewFileServiceWithFileServer(server *fileserver.Server, index *search.Index, locks *retention.Manager) services.FileService {
+	store := server.Metadata
+	if store == nil {
+		store = metadata.NewStore(metadata.StoreOpts{})
+	}
+	return &FileServiceImpl{
+		metadata: store,
+		search:   index,
+		locks:    locks,
+		content:  &nodeContent{server: server},
+		policy:   server.Policy,
+	}
+}
+
+// NewFileServiceWithMetadata creates a file service backed by the given metadata store
+func NewFileServiceWithMetadata(store *metadata.Store) services.FileService {
+	return &FileServiceImpl{metadata: store, content: newMemoryContent()}
+}
+
+// recordToFile converts a metadata record to the REST file entity
+func recordToFile(rec metadata.FileRecord) types.File {
+	return types.File{
+		Key:         rec.Key,
+		Name:        rec.Name,
+		Size:        rec.Size,
+		ContentType: rec.ContentType,
+		Hash:        rec.Hash,
+		MerkleRoot:  rec.MerkleRoot,
+		CreatedAt:   rec.CreatedAt,
+		UpdatedAt:   rec.UpdatedAt,
+		Metadata:    rec.Metadata,
+		Tags:        rec.Tags,
+	}
+}
+
+func recordsToFiles(records []metadata.FileRecord) []types.File {
+	files := make([]types.File, len(records))
+	for i, rec := range records {
+		files[i] = recordToFile(rec)
+	}
+	return files
 }
 
 func (s *FileServiceImpl) ListFiles(ctx context.Context) ([]types.File, error) {
-	// TODO: Implement actual fileserver integration
-	// return s.server.ListFiles()
-
-	// Mock data for now
-	return []types.File{
-		{
-			Key:         "file1",
-			Name:        "example.txt",
-			Size:        1024,
-			ContentType: "text/plain",
-			Hash:        "abc123",
-			CreatedAt:   time.Now().Add(-time.Hour),
-			UpdatedAt:   time.Now(),
-			Metadata:    map[string]string{"owner": "user1"},
-			Replicas: []types.FileReplica{
-				{PeerID: "peer1", Status: "active", CreatedAt: time.Now()},
-			},
-		},
-	}, nil
+	return recordsToFiles(s.metadata.List()), nil
+}
+
+func (s *FileServiceImpl) SearchFiles(ctx context.Context, tags []string, attrs map[string]string) ([]types.File, error) {
+	return recordsToFiles(s.metadata.Search(metadata.Query{Tags: tags, Metadata: attrs})), nil
+}
+
+func (s *FileServiceImpl) GetUsage(ctx context.Context, prefix string, depth int) ([]metadata.PrefixUsage, error) {
+	return s.metadata.Usage(prefix, depth), nil
+}
+
+func (s *FileServiceImpl) GetTenantUsage(ctx context.Context) ([]metadata.TenantUsage, error) {
+	return s.metadata.TenantUsage(), nil
 }
 
 func (s *FileServiceImpl) GetFile(ctx context.Context, key string) (*types.File, error) {
-	// TODO: Implement actual fileserver integration
-	// return s.server.GetFile(key)
-
-	// Mock data for now
-	if key == "file1" {
-		return &types.File{
-			Key:         "file1",
-			Name:        "example.txt",
-			Size:        1024,
-			ContentType: "text/plain",
-			Hash:        "abc123",
-			CreatedAt:   time.Now().Add(-time.Hour),
-			UpdatedAt:   time.Now(),
-			Metadata:    map[string]string{"owner": "user1"},
-			Replicas: []types.FileReplica{
-				{PeerID: "peer1", Status: "active", CreatedAt: time.Now()},
-			},
-		}, nil
-	}
-	return nil, fmt.Errorf("file not found: %s", key)
-}
-
-func (s *FileServiceImpl) UploadFile(ctx context.Context, name string, data []byte, contentType string, metadata map[string]string) (*types.File, error) {
-	// TODO: Implement actual fileserver integration
-	// return s.server.Store(name, data, metadata)
-
-	// Mock implementation
-	hash := fmt.Sprintf("%x", sha256.Sum256(data))
-	key := fmt.Sprintf("file_%d", time.Now().Unix())
+	rec, err := s.metadata.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("file not found: %s", key)
+	}
+	file := recordToFile(rec)
+	return &file, nil
+}
+
+func (s *FileServiceImpl) DownloadFile(ctx context.Context, key string) (*types.File, []byte, error) {
+	file, err := s.GetFile(ctx, key)
+	if err != nil {
+		if p, ok := s.content.(puller); ok && p.Pulls(key) {
+			return s.downloadPulled(ctx, p, key)
+		}
+		return nil, nil, err
+	}
+
+	data, err := s.content.Get(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, data, nil
+}
 
+// downloadPulled downloads a file an edge node pulls from its origin. It
+// is described from its content, whose hash is the ETag the origin gives
+// it too.
+func (s *FileServiceImpl) downloadPulled(ctx context.Context, p puller, key string) (*types.File, []byte, error) {
+	data, err := p.Pull(ctx, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("file not found: %s", key)
+	}
 	return &types.File{
+		Key:         key,
+		Name:        directory.Base(key),
+		Size:        int64(len(data)),
+		ContentType: http.DetectContentType(data),
+		Hash:        fmt.Sprintf("%x", sha256.Sum256(data)),
+		MerkleRoot:  merkle.RootOf(data),
+	}, data, nil
+}
+
+// GetFileManifest returns the Merkle tree of a file, its content cut into
+// chunks of chunkSize bytes
+func (s *FileServiceImpl) GetFileManifest(ctx context.Context, key string, chunkSize int64) (*merkle.Manifest, error) {
+	if _, err := s.metadata.Get(key); err != nil {
+		return nil, fmt.Errorf("file not found: %s", key)
+	}
+	data, err := s.content.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return merkle.Build(bytes.NewReader(data), chunkSize)
+}
+
+func (s *FileServiceImpl) UploadFile(ctx context.Context, name string, data []byte, contentType string, attrs map[string]string, tags []string) (*types.File, error) {
+	return s.upload(ctx, fmt.Sprintf("file_%d", time.Now().UnixNano()), name, data, contentType, attrs, tags)
+}
+
+func (s *FileServiceImpl) UploadFileAt(ctx context.Context, key, name string, data []byte, contentType string, attrs map[string]string, tags []string) (*types.File, error) {
+	key, err := directory.CleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" || strings.HasSuffix(key, directory.Separator) {
+		return nil, fmt.Errorf("%w: %q is a folder", directory.ErrInvalidPath, key)
+	}
+	if name == "" {
+		name = directory.Base(key)
+	}
+	if err := s.checkDestination(ctx, key, true); err != nil {
+		return nil, err
+	}
+	return s.replace(ctx, key, name, data, contentType, attrs, tags)
+}
+
+// UploadFileIf uploads a file at key when the file stored there meets cond
+func (s *FileServiceImpl) UploadFileIf(ctx context.Context, key, name string, data []byte, contentType string, attrs map[string]string, tags []string, cond etag.Condition) (*types.File, error) {
+	s.conditional.Lock()
+	defer s.conditional.Unlock()
+	if err := s.checkCondition(key, cond); err != nil {
+		return nil, err
+	}
+	return s.UploadFileAt(ctx, key, name, data, contentType, attrs, tags)
+}
+
+// checkCondition checks cond against the ETag of the file at key, the hash
+// of its content
+func (s *FileServiceImpl) checkCondition(key string, cond etag.Condition) error {
+	if clean, err := directory.CleanKey(key); err == nil {
+		key = clean
+	}
+	rec, err := s.metadata.Get(key)
+	return cond.Check(rec.Hash, err == nil)
+}
+
+// replace uploads a file at a key checkDestination allowed writing to
+func (s *FileServiceImpl) replace(ctx context.Context, key, name string, data []byte, contentType string, attrs map[string]string, tags []string) (*types.File, error) {
+	// The node does not overwrite stored files
+	if s.content.Has(key) {
+		if err := s.content.Delete(ctx, key); err != nil {
+			return nil, err
+		}
+	}
+	return s.upload(ctx, key, name, data, contentType, attrs, tags)
+}
+
+func (s *FileServiceImpl) ComposeObject(ctx context.Context, req *requests.FileComposeRequest) (*types.File, error) {
+	to, err := directory.CleanKey(req.To)
+	if err != nil {
+		return nil, err
+	}
+	if to == "" || strings.HasSuffix(to, directory.Separator) {
+		return nil, fmt.Errorf("%w: %q is a folder", directory.ErrInvalidPath, req.To)
+	}
+	if err := metadata.ValidateAttributes(req.Tags, req.Metadata); err != nil {
+		return nil, fmt.Errorf("%w: %v", directory.ErrInvalidPath, err)
+	}
+
+	// Every source is read before anything is written, so the destination
+	// may be one of them
+	var data []byte
+	contentType := req.ContentType
+	for _, key := range req.Sources {
+		rec, err := s.metadata.Get(key)
+		if err != nil || rec.ContentType == directory.ContentType {
+			return nil, fmt.Errorf("%w: %s", directory.ErrNotFound, key)
+		}
+		if req.DeleteSources && key != to && s.locks != nil {
+			if err := s.locks.Check(ctx, key, retention.OpDelete, "api"); err != nil {
+				return nil, err
+			}
+		}
+		part, err := s.content.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, part...)
+		if contentType == "" {
+			contentType = rec.ContentType
+		}
+	}
+	if err := s.checkDestination(ctx, to, req.Overwrite); err != nil {
+		return nil, err
+	}
+	file, err := s.replace(ctx, to, directory.Base(to), data, contentType, req.Metadata, req.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.DeleteSources {
+		deleted := map[string]bool{to: true}
+		for _, key := range req.Sources {
+			if deleted[key] {
+				continue
+			}
+			deleted[key] = true
+			if err := s.DeleteFile(ctx, key); err != nil {
+				slog.Warn("failed to delete composed part", "key", key, "error", err)
+			}
+		}
+	}
+	return file, nil
+}
+
+func (s *FileServiceImpl) upload(ctx context.Context, key, name string, data []byte, contentType string, attrs map[string]string, tags []string) (*types.File, error) {
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))
+
+	// Store the content first so followers of the metadata log (e.g.
+	// geo-replication) never see a record before its content
+	report, err := s.content.Put(ctx, key, data)
+	if err != nil {
+		return nil, err
+	}
+	if report != nil {
+		tags, attrs = report.Apply(tags, attrs)
+	}
+	if s.media != nil {
+		if info := s.media.Ingest(ctx, key, contentType, data); info != nil {
+			attrs = maps.Clone(attrs)
+			if attrs == nil {
+				attrs = make(map[string]string, len(info))
+			}
+			maps.Copy(attrs, info)
+		}
+	}
+
+	entry, err := s.metadata.Put(metadata.FileRecord{
 		Key:         key,
 		Name:        name,
 		Size:        int64(len(data)),
 		ContentType: contentType,
 		Hash:        hash,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
-		Metadata:    metadata,
-		Replicas:    []types.FileReplica{},
-	}, nil
+		MerkleRoot:  merkle.RootOf(data),
+		Tags:        tags,
+		Metadata:    attrs,
+	})
+	if err != nil {
+		_ = s.content.Delete(ctx, key)
+		return nil, err
+	}
+	s.replicateAttributes(key)
+
+	if s.search != nil {
+		err := s.search.IndexContent(key, name, contentType, bytes.NewReader(data))
+		if err != nil && !errors.Is(err, search.ErrUnsupportedFormat) {
+			slog.Warn("failed to index uploaded file", "key", key, "error", err)
+		}
+	}
+
+	file := recordToFile(*entry.Record)
+	return &file, nil
 }
 
 func (s *FileServiceImpl) DeleteFile(ctx context.Context, key string) error {
-	// TODO: Implement actual fileserver integration
-	// return s.server.Delete(key)
+	if s.locks != nil {
+		if err := s.locks.Check(ctx, key, retention.OpDelete, "api"); err != nil {
+			return err
+		}
+	}
+	if _, err := s.metadata.Delete(key); err != nil {
+		return fmt.Errorf("file not found: %s: %w", key, err)
+	}
+	if err := s.content.Delete(ctx, key); err != nil {
+		slog.Warn("failed to delete file content", "key", key, "error", err)
+	}
+	s.dropDerived(ctx, key)
+	if s.search != nil {
+		s.search.Remove(key)
+	}
+	return nil
+}
 
-	// Mock implementation
-	if key == "file1" {
+// DeleteFileIf deletes the file at key when it meets cond
+func (s *FileServiceImpl) DeleteFileIf(ctx context.Context, key string, cond etag.Condition) error {
+	s.conditional.Lock()
+	defer s.conditional.Unlock()
+	if err := s.checkCondition(key, cond); err != nil {
+		return err
+	}
+	return s.DeleteFile(ctx, key)
+}
+
+// dropDerived deletes the thumbnails of a file that is gone
+func (s *FileServiceImpl) dropDerived(ctx context.Context, key string) {
+	if s.media == nil {
+		return
+	}
+	if err := s.media.Invalidate(ctx, key); err != nil {
+		slog.Warn("failed to delete thumbnails", "key", key, "error", err)
+	}
+}
+
+// checkDestination checks that a write may replace the file at key
+func (s *FileServiceImpl) checkDestination(ctx context.Context, key string, overwrite bool) error {
+	if _, err := s.metadata.Get(key); err != nil {
 		return nil
 	}
-	return fmt.Errorf("file not found: %s", key)
-}
-
-func (s *FileServiceImpl) UpdateFileMetadata(ctx context.Context, key string, metadata map[string]string) (*types.File, error) {
-	// TODO: Implement actual fileserver integration
-	// return s.server.UpdateMetadata(key, metadata)
-
-	// Mock implementation
-	if key == "file1" {
-		return &types.File{
-			Key:         "file1",
-			Name:        "example.txt",
-			Size:        1024,
-			ContentType: "text/plain",
-			Hash:        "abc123",
-			CreatedAt:   time.Now().Add(-time.Hour),
-			UpdatedAt:   time.Now(),
-			Metadata:    metadata,
-			Replicas: []types.FileReplica{
-				{PeerID: "peer1", Status: "active", CreatedAt: time.Now()},
-			},
-		}, nil
-	}
-	return nil, fmt.Errorf("file not found: %s", key)
+	if !overwrite {
+		return fmt.Errorf("%w: %s", directory.ErrExists, key)
+	}
+	if s.locks != nil {
+		return s.locks.Check(ctx, key, retention.OpOverwrite, "api")
+	}
+	return nil
+}
+
+// copyFile copies a file to another key on the server, setting attrs in
+// the metadata of the copy. Directory objects and records without content
+// copy their record only.
+func (s *FileServiceImpl) copyFile(ctx context.Context, from, to string, overwrite bool, attrs map[string]string) error {
+	rec, err := s.metadata.Get(from)
+	if err != nil {
+		return fmt.Errorf("%w: %s", directory.ErrNotFound, from)
+	}
+	if err := s.checkDestination(ctx, to, overwrite); err != nil {
+		return err
+	}
+
+	var data []byte
+	if s.content.Has(from) {
+		if err := s.content.Copy(ctx, from, to); err != nil {
+			return err
+		}
+		if s.search != nil {
+			data, _ = s.content.Get(ctx, to)
+		}
+	}
+	if _, err := s.metadata.Put(moveRecord(rec, to, attrs)); err != nil {
+		return err
+	}
+	s.replicateAttributes(to)
+	s.reindex(from, to, rec, data, false)
+	return nil
+}
+
+// renameFile moves a file to another key on the server without its content
+// passing through the client, setting attrs in its metadata
+func (s *FileServiceImpl) renameFile(ctx context.Context, from, to string, overwrite bool, attrs map[string]string) error {
+	rec, err := s.metadata.Get(from)
+	if err != nil {
+		return fmt.Errorf("%w: %s", directory.ErrNotFound, from)
+	}
+	if s.locks != nil {
+		if err := s.locks.Check(ctx, from, retention.OpDelete, "api"); err != nil {
+			return err
+		}
+	}
+	if err := s.checkDestination(ctx, to, overwrite); err != nil {
+		return err
+	}
+
+	var data []byte
+	if s.content.Has(from) {
+		if err := s.content.Rename(ctx, from, to); err != nil {
+			return err
+		}
+		if s.search != nil {
+			data, _ = s.content.Get(ctx, to)
+		}
+	}
+	// The record at the new key first, so the file never disappears from
+	// followers of the metadata log
+	if _, err := s.metadata.Put(moveRecord(rec, to, attrs)); err != nil {
+		return err
+	}
+	if _, err := s.metadata.Delete(from); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+		return err
+	}
+	s.replicateAttributes(to)
+	s.dropDerived(ctx, from)
+	s.reindex(from, to, rec, data, true)
+	return nil
+}
+
+// moveRecord returns rec under another key with attrs set in its
+// metadata. Noncurrent versions stay with the old key.
+func moveRecord(rec metadata.FileRecord, key string, attrs map[string]string) metadata.FileRecord {
+	rec.Key = key
+	rec.HashedKey = ""
+	rec.Versions = nil
+	if rec.ContentType == directory.ContentType {
+		rec.Name = directory.Base(key)
+	}
+	if len(attrs) > 0 {
+		rec.Metadata = maps.Clone(rec.Metadata)
+		if rec.Metadata == nil {
+			rec.Metadata = make(map[string]string, len(attrs))
+		}
+		maps.Copy(rec.Metadata, attrs)
+	}
+	return rec
+}
+
+func (s *FileServiceImpl) CopyObject(ctx context.Context, req *requests.FileCopyRequest) (*types.File, error) {
+	return s.transferObject(ctx, req, policy.OpCopy)
+}
+
+func (s *FileServiceImpl) MoveObject(ctx context.Context, req *requests.FileCopyRequest) (*types.File, error) {
+	return s.transferObject(ctx, req, policy.OpMove)
+}
+
+// transferObject copies or moves one file. The node's policy rules see the
+// key and tenant of the destination, and the file as source. Without a
+// policy nothing grants access to another tenant, so such copies are denied.
+func (s *FileServiceImpl) transferObject(ctx context.Context, req *requests.FileCopyRequest, op policy.Operation) (*types.File, error) {
+	to, err := directory.CleanKey(req.To)
+	if err != nil {
+		return nil, err
+	}
+	if to == "" || strings.HasSuffix(to, directory.Separator) {
+		return nil, fmt.Errorf("%w: %q is a folder", directory.ErrInvalidPath, req.To)
+	}
+	if to == req.From {
+		return nil, fmt.Errorf("%w: %s is copied or moved onto itself", directory.ErrInvalidPath, to)
+	}
+	if err := metadata.ValidateAttributes(nil, req.Metadata); err != nil {
+		return nil, fmt.Errorf("%w: %v", directory.ErrInvalidPath, err)
+	}
+	rec, err := s.metadata.Get(req.From)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", directory.ErrNotFound, req.From)
+	}
+	if rec.ContentType == directory.ContentType {
+		return nil, fmt.Errorf("%w: %s is a folder", directory.ErrInvalidPath, req.From)
+	}
+
+	attrs := maps.Clone(req.Metadata)
+	source := rec.Tenant()
+	tenant := source
+	if req.Tenant != "" && req.Tenant != source {
+		tenant = req.Tenant
+		if attrs == nil {
+			attrs = make(map[string]string, 1)
+		}
+		attrs["tenant"] = tenant
+	}
+	if s.policy == nil && tenant != source {
+		return nil, fmt.Errorf("%w: copying %s to tenant %q needs a policy allowing it", policy.ErrDenied, req.From, tenant)
+	}
+	if s.policy != nil {
+		moved := moveRecord(rec, to, attrs)
+		err := s.policy.Check(policy.Input{
+			Operation:   op,
+			Key:         to,
+			Size:        rec.Size,
+			ContentType: rec.ContentType,
+			Tenant:      tenant,
+			Tags:        rec.Tags,
+			Metadata:    moved.Metadata,
+			Source:      policy.Source{Key: req.From, Tenant: source},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if op == policy.OpMove {
+		err = s.renameFile(ctx, req.From, to, req.Overwrite, attrs)
+	} else {
+		err = s.copyFile(ctx, req.From, to, req.Overwrite, attrs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetFile(ctx, to)
+}
+
+// reindex indexes the text of a copied or moved file under its new key
+func (s *FileServiceImpl) reindex(from, to string, rec metadata.FileRecord, data []byte, moved bool) {
+	if s.search == nil {
+		return
+	}
+	if moved {
+		s.search.Remove(from)
+	}
+	if data == nil {
+		return
+	}
+	err := s.search.IndexContent(to, rec.Name, rec.ContentType, bytes.NewReader(data))
+	if err != nil && !errors.Is(err, search.ErrUnsupportedFormat) {
+		slog.Warn("failed to index file", "key", to, "error", err)
+	}
+}
+
+func (s *FileServiceImpl) UpdateFileMetadata(ctx context.Context, key string, attrs map[string]string) (*types.File, error) {
+	rec, err := s.metadata.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("file not found: %s", key)
+	}
+
+	rec.Metadata = attrs
+	entry, err := s.metadata.Put(rec)
+	if err != nil {
+		return nil, err
+	}
+	s.replicateAttributes(key)
+	file := recordToFile(*entry.Record)
+	return &file, nil
+}
+
+func (s *FileServiceImpl) PatchFileMetadata(ctx context.Context, key string, patch *requests.FileMetadataPatchRequest) (*types.File, error) {
+	rec, err := s.metadata.UpdateAttributes(key, metadata.AttributePatch{
+		Set:        patch.Set,
+		Remove:     patch.Remove,
+		AddTags:    patch.AddTags,
+		RemoveTags: patch.RemoveTags,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.replicateAttributes(key)
+	file := recordToFile(rec)
+	return &file, nil
+}
+
+// replicateAttributes sends the tags and metadata of key to the other nodes
+// holding the file, when its content lives on a node
+func (s *FileServiceImpl) replicateAttributes(key string) {
+	r, ok := s.content.(attributeReplicator)
+	if !ok {
+		return
+	}
+	if err := r.ReplicateAttributes(key); err != nil {
+		slog.Warn("failed to replicate file attributes", "key", key, "error", err)
+	}
 }
@@ -0,0 +1,85 @@
+package implementations
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/firmware"
+)
+
+type FirmwareServiceImpl struct {
+	manager *firmware.Manager
+}
+
+func NewFirmwareService(manager *firmware.Manager) services.FirmwareService {
+	return &FirmwareServiceImpl{manager: manager}
+}
+
+func (s *FirmwareServiceImpl) ListImages(ctx context.Context) []firmware.Image {
+	return s.manager.Images()
+}
+
+func (s *FirmwareServiceImpl) GetImage(ctx context.Context, id string) (firmware.Image, error) {
+	return s.manager.Image(id)
+}
+
+func (s *FirmwareServiceImpl) UploadImage(ctx context.Context, name, version string, data []byte, comment, actor string) (firmware.Image, error) {
+	return s.manager.AddImage(ctx, name, version, data, comment, actor)
+}
+
+func (s *FirmwareServiceImpl) DeleteImage(ctx context.Context, id, actor string) error {
+	return s.manager.DeleteImage(ctx, id, actor)
+}
+
+func (s *FirmwareServiceImpl) ImageContent(ctx context.Context, id string) (firmware.Image, []byte, error) {
+	img, err := s.manager.Image(id)
+	if err != nil {
+		return firmware.Image{}, nil, err
+	}
+	data, err := s.manager.Content(ctx, id)
+	return img, data, err
+}
+
+func (s *FirmwareServiceImpl) SigningKey(ctx context.Context) firmware.SigningKey {
+	return s.manager.SigningKey()
+}
+
+func (s *FirmwareServiceImpl) ListRollouts(ctx context.Context) []firmware.RolloutStatus {
+	return s.manager.Rollouts()
+}
+
+func (s *FirmwareServiceImpl) GetRollout(ctx context.Context, id string) (firmware.RolloutStatus, []firmware.DeviceStatus, error) {
+	return s.manager.Rollout(id)
+}
+
+func (s *FirmwareServiceImpl) CreateRollout(ctx context.Context, req firmware.RolloutRequest, actor string) (firmware.Rollout, error) {
+	return s.manager.CreateRollout(ctx, req, actor)
+}
+
+func (s *FirmwareServiceImpl) AdvanceRollout(ctx context.Context, id, actor string) (firmware.Rollout, error) {
+	return s.manager.Advance(ctx, id, actor)
+}
+
+func (s *FirmwareServiceImpl) PauseRollout(ctx context.Context, id, reason, actor string) (firmware.Rollout, error) {
+	return s.manager.Pause(ctx, id, reason, actor)
+}
+
+func (s *FirmwareServiceImpl) ResumeRollout(ctx context.Context, id, actor string) (firmware.Rollout, error) {
+	return s.manager.Resume(ctx, id, actor)
+}
+
+func (s *FirmwareServiceImpl) CancelRollout(ctx context.Context, id, actor string) error {
+	return s.manager.Cancel(ctx, id, actor)
+}
+
+func (s *FirmwareServiceImpl) CheckUpdate(ctx context.Context, device, version string) (firmware.Manifest, error) {
+	return s.manager.Check(ctx, device, version)
+}
+
+func (s *FirmwareServiceImpl) ReportStatus(ctx context.Context, device string, report firmware.StatusReport) (firmware.DeviceStatus, error) {
+	return s.manager.Report(ctx, device, report)
+}
+
+func (s *FirmwareServiceImpl) GetDeviceStatus(ctx context.Context, device string) (firmware.DeviceStatus, error) {
+	return s.manager.Status(device)
+}
@@ -0,0 +1,21 @@
+package implementations
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/storage"
+)
+
+type FsckServiceImpl struct {
+	server *fileserver.Server
+}
+
+func NewFsckService(server *fileserver.Server) services.FsckService {
+	return &FsckServiceImpl{server: server}
+}
+
+func (s *FsckServiceImpl) Check(ctx context.Context) (*storage.CheckReport, error) {
+	return s.server.Storage().Check()
+}
@@ -0,0 +1,114 @@
+package implementations
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/georeplication"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+	"github.com/Skpow1234/Peervault/internal/retention"
+	"github.com/Skpow1234/Peervault/internal/search"
+)
+
+type GeoReplicationServiceImpl struct {
+	node *georeplication.Node
+}
+
+func NewGeoReplicationService(node *georeplication.Node) services.GeoReplicationService {
+	return &GeoReplicationServiceImpl{node: node}
+}
+
+// NewGeoReplicationLocal exposes the files of a file service to
+// geo-replication. Replicated writes honour retention locks like any other.
+func NewGeoReplicationLocal(files services.FileService) (georeplication.Local, error) {
+	impl, ok := files.(*FileServiceImpl)
+	if !ok {
+		return nil, errors.New("geo-replication requires the metadata-backed file service")
+	}
+	return &fileReplicationStore{files: impl}, nil
+}
+
+func (s *GeoReplicationServiceImpl) GetStatus(ctx context.Context) (georeplication.Status, error) {
+	return s.node.Status(), nil
+}
+
+// fileReplicationStore adapts FileServiceImpl to geo-replication
+type fileReplicationStore struct {
+	files *FileServiceImpl
+}
+
+func (s *fileReplicationStore) Store() *metadata.Store {
+	return s.files.metadata
+}
+
+func (s *fileReplicationStore) Content(key string) ([]byte, bool) {
+	data, err := s.files.content.Get(context.Background(), key)
+	return data, err == nil
+}
+
+func (s *fileReplicationStore) Put(ctx context.Context, rec metadata.FileRecord, content []byte) (metadata.Entry, error) {
+	if _, err := s.files.metadata.Get(rec.Key); err == nil && s.files.locks != nil {
+		if err := s.files.locks.Check(ctx, rec.Key, retention.OpOverwrite, "replication"); err != nil {
+			return metadata.Entry{}, err
+		}
+	}
+
+	// Content goes first so followers never see the record without it
+	previous, prevErr := s.files.content.Get(ctx, rec.Key)
+	if err := s.putContent(ctx, rec.Key, content); err != nil {
+		return metadata.Entry{}, err
+	}
+
+	entry, err := s.files.metadata.Put(rec)
+	if err != nil {
+		if prevErr == nil {
+			_ = s.putContent(ctx, rec.Key, previous)
+		} else {
+			_ = s.files.content.Delete(ctx, rec.Key)
+		}
+		return metadata.Entry{}, err
+	}
+
+	if s.files.search != nil {
+		if content == nil {
+			s.files.search.Remove(rec.Key)
+		} else {
+			err := s.files.search.IndexContent(rec.Key, rec.Name, rec.ContentType, bytes.NewReader(content))
+			if err != nil && !errors.Is(err, search.ErrUnsupportedFormat) {
+				slog.Warn("failed to index replicated file", "key", rec.Key, "error", err)
+			}
+		}
+	}
+	return entry, nil
+}
+
+func (s *fileReplicationStore) Delete(ctx context.Context, key string) (metadata.Entry, error) {
+	if s.files.locks != nil {
+		if err := s.files.locks.Check(ctx, key, retention.OpDelete, "replication"); err != nil {
+			return metadata.Entry{}, err
+		}
+	}
+	entry, err := s.files.metadata.Delete(key)
+	if err != nil {
+		return metadata.Entry{}, err
+	}
+	if err := s.files.content.Delete(ctx, key); err != nil {
+		slog.Warn("failed to delete replicated file content", "key", key, "error", err)
+	}
+	if s.files.search != nil {
+		s.files.search.Remove(key)
+	}
+	return entry, nil
+}
+
+// putContent stores content, or removes it when nil
+func (s *fileReplicationStore) putContent(ctx context.Context, key string, content []byte) error {
+	if content == nil {
+		return s.files.content.Delete(ctx, key)
+	}
+	_, err := s.files.content.Put(ctx, key, content)
+	return err
+}
@@ -0,0 +1,40 @@
+package implementations
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/grafana"
+)
+
+type GrafanaServiceImpl struct {
+	datasource *grafana.Datasource
+}
+
+func NewGrafanaService(server *fileserver.Server) services.GrafanaService {
+	return &GrafanaServiceImpl{datasource: &grafana.Datasource{Node: server.ID, Metrics: server, Analytics: server.Analytics}}
+}
+
+func (s *GrafanaServiceImpl) Search(ctx context.Context, target string) ([]string, error) {
+	if slices.Contains(grafana.Labels, target) {
+		return s.datasource.LabelValues(target)
+	}
+	targets := []string{}
+	for _, t := range s.datasource.Targets() {
+		if strings.Contains(t, target) {
+			targets = append(targets, t)
+		}
+	}
+	return targets, nil
+}
+
+func (s *GrafanaServiceImpl) LabelValues(ctx context.Context, label string) ([]string, error) {
+	return s.datasource.LabelValues(label)
+}
+
+func (s *GrafanaServiceImpl) Query(ctx context.Context, req *grafana.QueryRequest) ([]any, error) {
+	return s.datasource.Query(*req)
+}
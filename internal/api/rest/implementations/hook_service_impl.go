@@ -0,0 +1,20 @@
+package implementations
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/hooks"
+)
+
+type HookServiceImpl struct {
+	engine *hooks.Engine
+}
+
+func NewHookService(engine *hooks.Engine) services.HookService {
+	return &HookServiceImpl{engine: engine}
+}
+
+func (s *HookServiceImpl) ListHooks(ctx context.Context) ([]hooks.Stats, error) {
+	return s.engine.Stats(), nil
+}
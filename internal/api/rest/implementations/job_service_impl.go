@@ -0,0 +1,55 @@
+package implementations
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/scheduler"
+)
+
+type JobServiceImpl struct {
+	jobs *scheduler.Scheduler
+}
+
+func NewJobService(jobs *scheduler.Scheduler) services.JobService {
+	return &JobServiceImpl{jobs: jobs}
+}
+
+func (s *JobServiceImpl) ListJobs(ctx context.Context) []scheduler.JobStatus {
+	return s.jobs.Jobs()
+}
+
+func (s *JobServiceImpl) GetJob(ctx context.Context, name string) (*responses.JobResponse, error) {
+	status, runs, err := s.jobs.Job(name)
+	if err != nil {
+		return nil, err
+	}
+	if runs == nil {
+		runs = []scheduler.Run{}
+	}
+	return &responses.JobResponse{JobStatus: status, Runs: runs}, nil
+}
+
+func (s *JobServiceImpl) UpdateJob(ctx context.Context, name string, req *requests.JobRequest) (scheduler.JobStatus, error) {
+	status, _, err := s.jobs.Job(name)
+	if err != nil {
+		return scheduler.JobStatus{}, err
+	}
+	if req.Schedule != nil {
+		if status, err = s.jobs.SetSchedule(name, *req.Schedule); err != nil {
+			return scheduler.JobStatus{}, err
+		}
+	}
+	if req.Paused != nil {
+		if status, err = s.jobs.Pause(name, *req.Paused); err != nil {
+			return scheduler.JobStatus{}, err
+		}
+	}
+	return status, nil
+}
+
+func (s *JobServiceImpl) RunJob(ctx context.Context, name string) (scheduler.Run, error) {
+	return s.jobs.Trigger(name)
+}
@@ -0,0 +1,44 @@
+package implementations
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/join"
+)
+
+type JoinTokenServiceImpl struct {
+	server *fileserver.Server
+}
+
+func NewJoinTokenService(server *fileserver.Server) services.JoinTokenService {
+	return &JoinTokenServiceImpl{server: server}
+}
+
+func (s *JoinTokenServiceImpl) ListTokens(ctx context.Context) []join.Record {
+	return s.server.JoinTokens.Tokens()
+}
+
+func (s *JoinTokenServiceImpl) CreateToken(ctx context.Context, req *requests.JoinTokenRequest, actor string) (join.Record, string, error) {
+	opts := join.CreateOptions{MaxUses: req.MaxUses, Comment: req.Comment}
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			return join.Record{}, "", fmt.Errorf("%w: invalid TTL %q", join.ErrInvalidToken, req.TTL)
+		}
+		opts.TTL = ttl
+	}
+	record, token, err := s.server.JoinTokens.Create(ctx, opts, actor)
+	if err != nil {
+		return join.Record{}, "", err
+	}
+	return record, token.String(), nil
+}
+
+func (s *JoinTokenServiceImpl) RevokeToken(ctx context.Context, id, actor string) error {
+	return s.server.JoinTokens.Revoke(ctx, id, actor)
+}
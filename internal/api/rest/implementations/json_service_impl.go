@@ -0,0 +1,126 @@
+package implementations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types"
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"github.com/Skpow1234/Peervault/internal/jsondoc"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+)
+
+type JSONServiceImpl struct {
+	files   *FileServiceImpl
+	schemas *jsondoc.Schemas
+
+	// mu makes checking the ETag of a document and writing it one step
+	mu sync.Mutex
+}
+
+// NewJSONService creates the JSON document view of the files of a file
+// service. Documents are validated against schemas when they are set.
+func NewJSONService(files services.FileService, schemas *jsondoc.Schemas) (services.JSONService, error) {
+	impl, ok := files.(*FileServiceImpl)
+	if !ok {
+		return nil, errors.New("JSON documents require the metadata-backed file service")
+	}
+	return &JSONServiceImpl{files: impl, schemas: schemas}, nil
+}
+
+func (s *JSONServiceImpl) GetJSON(ctx context.Context, key string) (*types.File, []byte, error) {
+	rec, err := s.record(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err := s.files.content.Get(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	file := recordToFile(rec)
+	return &file, doc, nil
+}
+
+func (s *JSONServiceImpl) PutJSON(ctx context.Context, key string, doc []byte, cond jsondoc.Condition) (*types.File, []byte, bool, error) {
+	doc, err := jsondoc.Normalize(doc)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, err := s.record(key)
+	exists := err == nil
+	if err != nil && !errors.Is(err, metadata.ErrNotFound) {
+		return nil, nil, false, err
+	}
+	if err := cond.Check(rec.Hash, exists); err != nil {
+		return nil, nil, false, err
+	}
+	file, err := s.write(ctx, key, doc, rec)
+	return file, doc, !exists, err
+}
+
+func (s *JSONServiceImpl) PatchJSON(ctx context.Context, key, patchType string, patch []byte, cond jsondoc.Condition) (*types.File, []byte, error) {
+	apply := jsondoc.Patch
+	if patchType == jsondoc.MergePatchType {
+		apply = jsondoc.MergePatch
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, err := s.record(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cond.Check(rec.Hash, true); err != nil {
+		return nil, nil, err
+	}
+	current, err := s.files.content.Get(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err := apply(current, patch)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := s.write(ctx, key, doc, rec)
+	return file, doc, err
+}
+
+func (s *JSONServiceImpl) DeleteJSON(ctx context.Context, key string, cond jsondoc.Condition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, err := s.record(key)
+	if err != nil {
+		return err
+	}
+	if err := cond.Check(rec.Hash, true); err != nil {
+		return err
+	}
+	return s.files.DeleteFile(ctx, key)
+}
+
+// record returns the record of the document at key
+func (s *JSONServiceImpl) record(key string) (metadata.FileRecord, error) {
+	rec, err := s.files.metadata.Get(key)
+	if err != nil {
+		return metadata.FileRecord{}, fmt.Errorf("%w: %s", metadata.ErrNotFound, key)
+	}
+	if !jsondoc.IsDocument(rec.ContentType) {
+		return metadata.FileRecord{}, fmt.Errorf("%w: %s is %s", jsondoc.ErrNotDocument, key, rec.ContentType)
+	}
+	return rec, nil
+}
+
+// write validates a document and stores it, keeping the tags and metadata
+// of the file it replaces
+func (s *JSONServiceImpl) write(ctx context.Context, key string, doc []byte, prev metadata.FileRecord) (*types.File, error) {
+	if err := s.schemas.Validate(key, doc); err != nil {
+		return nil, err
+	}
+	return s.files.UploadFileAt(ctx, key, directory.Base(key), doc, jsondoc.ContentType, prev.Metadata, prev.Tags)
+}
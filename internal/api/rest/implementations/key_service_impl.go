@@ -0,0 +1,24 @@
+package implementations
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+)
+
+type KeyServiceImpl struct {
+	server *fileserver.Server
+}
+
+func NewKeyService(server *fileserver.Server) services.KeyService {
+	return &KeyServiceImpl{server: server}
+}
+
+func (s *KeyServiceImpl) Status(ctx context.Context) (fileserver.KeyRotationStatus, error) {
+	return s.server.KeyRotation()
+}
+
+func (s *KeyServiceImpl) Rotate(ctx context.Context) (fileserver.KeyRotationStatus, error) {
+	return s.server.RotateKey()
+}
@@ -0,0 +1,51 @@
+package implementations
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/consensus"
+)
+
+type LeaseServiceImpl struct {
+	cluster *consensus.Client
+}
+
+func NewLeaseService(cluster *consensus.Client) services.LeaseService {
+	return &LeaseServiceImpl{cluster: cluster}
+}
+
+func (s *LeaseServiceImpl) ListLeases(ctx context.Context) ([]consensus.Lease, error) {
+	return s.cluster.Leases(ctx)
+}
+
+func (s *LeaseServiceImpl) GetLease(ctx context.Context, name string) (*consensus.Lease, error) {
+	lease, err := s.cluster.Lease(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &lease, nil
+}
+
+func (s *LeaseServiceImpl) AcquireLease(ctx context.Context, name string, req *requests.LeaseAcquireRequest) (*consensus.Lease, error) {
+	if req.Holder == "" {
+		return nil, errors.New("holder is required")
+	}
+	if req.TTLSeconds <= 0 {
+		return nil, errors.New("ttl_seconds must be positive")
+	}
+	lease, err := s.cluster.AcquireLease(ctx, name, consensus.LeaseRequest{Holder: req.Holder, TTLSeconds: req.TTLSeconds})
+	if err != nil {
+		return nil, err
+	}
+	return &lease, nil
+}
+
+func (s *LeaseServiceImpl) ReleaseLease(ctx context.Context, name, holder string) error {
+	if holder == "" {
+		return errors.New("holder is required")
+	}
+	return s.cluster.ReleaseLease(ctx, name, holder)
+}
@@ -0,0 +1,47 @@
+package implementations
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/lifecycle"
+)
+
+type LifecycleServiceImpl struct {
+	scheduler *lifecycle.Scheduler
+}
+
+func NewLifecycleService(scheduler *lifecycle.Scheduler) services.LifecycleService {
+	return &LifecycleServiceImpl{scheduler: scheduler}
+}
+
+// NewLifecycleTarget applies lifecycle actions to the files of a file
+// service, expiring through DeleteFile so contents and search stay in sync
+func NewLifecycleTarget(files services.FileService) (lifecycle.Target, error) {
+	impl, ok := files.(*FileServiceImpl)
+	if !ok {
+		return nil, errors.New("lifecycle rules require the metadata-backed file service")
+	}
+	return lifecycle.NewMetadataTarget(impl.metadata, impl.DeleteFile), nil
+}
+
+func (s *LifecycleServiceImpl) GetPolicy(ctx context.Context) (lifecycle.Policy, error) {
+	return s.scheduler.Policy(), nil
+}
+
+func (s *LifecycleServiceImpl) SetPolicy(ctx context.Context, policy lifecycle.Policy) error {
+	return s.scheduler.SetPolicy(policy)
+}
+
+func (s *LifecycleServiceImpl) Run(ctx context.Context, dryRun bool) (*lifecycle.Report, error) {
+	return s.scheduler.Run(ctx, dryRun)
+}
+
+func (s *LifecycleServiceImpl) LastReport(ctx context.Context) (*lifecycle.Report, error) {
+	return s.scheduler.LastReport(), nil
+}
+
+func (s *LifecycleServiceImpl) Enforcing() bool {
+	return s.scheduler.Enforcing()
+}
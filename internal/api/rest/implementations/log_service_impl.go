@@ -0,0 +1,58 @@
+package implementations
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/streamlog"
+)
+
+type LogServiceImpl struct {
+	logs *streamlog.Manager
+}
+
+// NewLogService creates append-only logs kept in the content store of a
+// file service, so their segments are replicated like the files
+func NewLogService(files services.FileService, cfg streamlog.Config) (*LogServiceImpl, error) {
+	impl, ok := files.(*FileServiceImpl)
+	if !ok {
+		return nil, errors.New("logs require the metadata-backed file service")
+	}
+	return &LogServiceImpl{logs: streamlog.New(cfg, &derivedStore{files: impl})}, nil
+}
+
+// Start applies the retention of the logs to those no longer appended to
+func (s *LogServiceImpl) Start() { s.logs.Start() }
+
+// Stop stops applying retention
+func (s *LogServiceImpl) Stop() { s.logs.Stop() }
+
+func (s *LogServiceImpl) ListLogs(ctx context.Context) ([]streamlog.Info, error) {
+	return s.logs.List(ctx)
+}
+
+func (s *LogServiceImpl) GetLog(ctx context.Context, name string) (streamlog.Info, error) {
+	return s.logs.Info(ctx, name)
+}
+
+func (s *LogServiceImpl) DeleteLog(ctx context.Context, name string) error {
+	return s.logs.Delete(ctx, name)
+}
+
+func (s *LogServiceImpl) AppendRecords(ctx context.Context, name string, data [][]byte) ([]streamlog.Record, error) {
+	return s.logs.Append(ctx, name, data...)
+}
+
+func (s *LogServiceImpl) ReadRecords(ctx context.Context, name string, q streamlog.Query) ([]streamlog.Record, error) {
+	return s.logs.Read(ctx, name, q)
+}
+
+func (s *LogServiceImpl) WaitRecords(ctx context.Context, name string, offset uint64) error {
+	return s.logs.Wait(ctx, name, offset)
+}
+
+func (s *LogServiceImpl) TruncateLog(ctx context.Context, name string, offset uint64, before time.Time) (streamlog.Info, error) {
+	return s.logs.Truncate(ctx, name, offset, before)
+}
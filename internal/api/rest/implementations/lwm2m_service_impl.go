@@ -0,0 +1,55 @@
+package implementations
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/lwm2m"
+)
+
+type LwM2MServiceImpl struct {
+	server *lwm2m.Server
+}
+
+func NewLwM2MService(server *lwm2m.Server) services.LwM2MService {
+	return &LwM2MServiceImpl{server: server}
+}
+
+func (s *LwM2MServiceImpl) ListClients(ctx context.Context) []lwm2m.Registration {
+	return s.server.Clients()
+}
+
+func (s *LwM2MServiceImpl) GetClient(ctx context.Context, endpoint string) (lwm2m.Registration, error) {
+	return s.server.Client(endpoint)
+}
+
+func (s *LwM2MServiceImpl) Read(ctx context.Context, endpoint, path string) ([]lwm2m.Value, error) {
+	return s.server.Read(ctx, endpoint, path)
+}
+
+func (s *LwM2MServiceImpl) Write(ctx context.Context, endpoint, path string, value any, actor string) error {
+	return s.server.Write(ctx, endpoint, path, value, actor)
+}
+
+func (s *LwM2MServiceImpl) Execute(ctx context.Context, endpoint, path, args, actor string) error {
+	return s.server.Execute(ctx, endpoint, path, args, actor)
+}
+
+func (s *LwM2MServiceImpl) Observe(ctx context.Context, endpoint, path string) ([]lwm2m.Value, error) {
+	return s.server.Observe(ctx, endpoint, path)
+}
+
+func (s *LwM2MServiceImpl) CancelObservation(ctx context.Context, endpoint, path string) error {
+	return s.server.CancelObservation(endpoint, path)
+}
+
+func (s *LwM2MServiceImpl) UpdateFirmware(ctx context.Context, endpoint, packageURI, actor string) (lwm2m.FirmwareUpdate, error) {
+	return s.server.UpdateFirmware(ctx, endpoint, packageURI, actor)
+}
+
+func (s *LwM2MServiceImpl) ListObjects(ctx context.Context) []lwm2m.ObjectDef {
+	return slices.SortedFunc(maps.Values(lwm2m.Objects), func(a, b lwm2m.ObjectDef) int { return cmp.Compare(a.ID, b.ID) })
+}
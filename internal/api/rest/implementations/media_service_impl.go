@@ -0,0 +1,184 @@
+package implementations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types"
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"github.com/Skpow1234/Peervault/internal/media"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+	"github.com/Skpow1234/Peervault/internal/queue"
+)
+
+// defaultThumbnailSize is the longest edge of thumbnails requested without
+// a size, when the pipeline makes it
+const defaultThumbnailSize = 256
+
+type MediaServiceImpl struct {
+	files    *FileServiceImpl
+	pipeline *media.Pipeline
+}
+
+// NewMediaService creates thumbnails and streams of the files of a file
+// service. From then on the service records the dimensions of the images
+// and videos it stores, and drops their thumbnails and segments when they
+// change. With a job queue, thumbnails rendered on ingest are rendered by
+// its jobs.
+func NewMediaService(files services.FileService, cfg media.Config, jobs *queue.Queue, logger *slog.Logger) (services.MediaService, error) {
+	impl, ok := files.(*FileServiceImpl)
+	if !ok {
+		return nil, errors.New("thumbnails require the metadata-backed file service")
+	}
+	pipeline := media.New(cfg, &derivedStore{files: impl}, logger)
+	if jobs != nil {
+		if err := pipeline.Defer(jobs, impl.loadMedia); err != nil {
+			return nil, err
+		}
+	}
+	impl.media = pipeline
+	return &MediaServiceImpl{files: impl, pipeline: pipeline}, nil
+}
+
+// loadMedia reads a file for the thumbnail jobs of the pipeline
+func (s *FileServiceImpl) loadMedia(ctx context.Context, key string) (string, []byte, error) {
+	rec, err := s.metadata.Get(key)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return "", nil, fmt.Errorf("%w: %s", os.ErrNotExist, key)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := s.content.Get(ctx, key)
+	if err != nil {
+		return "", nil, err
+	}
+	return rec.ContentType, data, nil
+}
+
+func (s *MediaServiceImpl) GetThumbnail(ctx context.Context, key string, size int) (*types.File, []byte, error) {
+	rec, err := s.files.metadata.Get(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", metadata.ErrNotFound, key)
+	}
+	if rec.ContentType == directory.ContentType {
+		return nil, nil, fmt.Errorf("%w: %s is a folder", media.ErrUnsupported, key)
+	}
+	if size == 0 {
+		size = s.defaultSize()
+	}
+	thumb, err := s.pipeline.Thumbnail(ctx, key, rec.ContentType, size, func() ([]byte, error) {
+		return s.files.content.Get(ctx, key)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	file := recordToFile(rec)
+	return &file, thumb, nil
+}
+
+func (s *MediaServiceImpl) ThumbnailSizes() []int {
+	return s.pipeline.Sizes()
+}
+
+func (s *MediaServiceImpl) GetPlaylist(ctx context.Context, key, profile string) (*types.File, []byte, error) {
+	rec, info, err := s.stream(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	var playlist []byte
+	if profile == "" {
+		playlist, err = s.pipeline.MasterPlaylist(info)
+	} else {
+		playlist, err = s.pipeline.MediaPlaylist(info, profile)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	file := recordToFile(rec)
+	return &file, playlist, nil
+}
+
+func (s *MediaServiceImpl) GetSegment(ctx context.Context, key, profile string, n int) (*types.File, []byte, error) {
+	rec, info, err := s.stream(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	segment, err := s.pipeline.Segment(ctx, key, info, profile, n, func() ([]byte, error) {
+		return s.files.content.Get(ctx, key)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	file := recordToFile(rec)
+	return &file, segment, nil
+}
+
+// stream returns the record of a file and its media information, probing
+// files stored before media was enabled
+func (s *MediaServiceImpl) stream(ctx context.Context, key string) (metadata.FileRecord, media.Info, error) {
+	rec, err := s.files.metadata.Get(key)
+	if err != nil {
+		return metadata.FileRecord{}, media.Info{}, fmt.Errorf("%w: %s", metadata.ErrNotFound, key)
+	}
+	if rec.ContentType == directory.ContentType {
+		return metadata.FileRecord{}, media.Info{}, fmt.Errorf("%w: %s is a folder", media.ErrUnsupported, key)
+	}
+	if info, ok := media.InfoOf(rec.Metadata); ok {
+		return rec, info, nil
+	}
+	data, err := s.files.content.Get(ctx, key)
+	if err != nil {
+		return metadata.FileRecord{}, media.Info{}, err
+	}
+	info, err := s.pipeline.Probe(ctx, rec.ContentType, data)
+	if err != nil {
+		return metadata.FileRecord{}, media.Info{}, err
+	}
+	return rec, info, nil
+}
+
+// defaultSize is the smallest size from defaultThumbnailSize up, or else
+// the largest size
+func (s *MediaServiceImpl) defaultSize() int {
+	sizes := s.pipeline.Sizes()
+	for _, size := range sizes {
+		if size >= defaultThumbnailSize {
+			return size
+		}
+	}
+	return sizes[len(sizes)-1]
+}
+
+// derivedStore keeps thumbnails, stream segments and logs in the content store of the file service,
+// without metadata records, so they are not listed as files
+type derivedStore struct {
+	files *FileServiceImpl
+}
+
+func (d *derivedStore) Has(key string) bool {
+	return d.files.content.Has(key)
+}
+
+func (d *derivedStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return d.files.content.Get(ctx, key)
+}
+
+func (d *derivedStore) Put(ctx context.Context, key string, data []byte) error {
+	// The node does not overwrite stored files
+	if d.files.content.Has(key) {
+		if err := d.files.content.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	_, err := d.files.content.Put(ctx, key, data)
+	return err
+}
+
+func (d *derivedStore) Delete(ctx context.Context, key string) error {
+	return d.files.content.Delete(ctx, key)
+}
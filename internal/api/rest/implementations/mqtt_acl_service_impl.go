@@ -0,0 +1,36 @@
+package implementations
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/mqtt/topicacl"
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+)
+
+type MQTTACLServiceImpl struct {
+	acl *topicacl.ACL
+}
+
+func NewMQTTACLService(acl *topicacl.ACL) services.MQTTACLService {
+	return &MQTTACLServiceImpl{acl: acl}
+}
+
+func (s *MQTTACLServiceImpl) ListRules(ctx context.Context) []topicacl.Rule {
+	return s.acl.Rules()
+}
+
+func (s *MQTTACLServiceImpl) AddRule(ctx context.Context, req *requests.MQTTACLRuleRequest, actor string) (topicacl.Rule, error) {
+	return s.acl.AddRule(ctx, topicacl.Rule{
+		Action:  topicacl.Action(req.Action),
+		Access:  topicacl.Access(req.Access),
+		Topic:   req.Topic,
+		Device:  req.Device,
+		Tenant:  req.Tenant,
+		Comment: req.Comment,
+	}, actor)
+}
+
+func (s *MQTTACLServiceImpl) RemoveRule(ctx context.Context, id, actor string) error {
+	return s.acl.RemoveRule(ctx, id, actor)
+}
@@ -0,0 +1,68 @@
+package implementations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"mime"
+	"net/http"
+	"path"
+
+	"github.com/Skpow1234/Peervault/internal/api/nfs"
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/directory"
+)
+
+// nfsStore adapts FileServiceImpl to nfs.Store: the folder view of the
+// metadata and the content of files. Files written over NFS keep their
+// name, tags and metadata.
+type nfsStore struct {
+	fileDirectoryStore
+}
+
+// NewNFSStore returns the files of a file service for the NFS gateway
+func NewNFSStore(files services.FileService) (nfs.Store, error) {
+	impl, ok := files.(*FileServiceImpl)
+	if !ok {
+		return nil, errors.New("the NFS gateway requires the metadata-backed file service")
+	}
+	return &nfsStore{fileDirectoryStore{files: impl}}, nil
+}
+
+func (s *nfsStore) Read(ctx context.Context, key string) ([]byte, error) {
+	if _, err := s.files.metadata.Get(key); err != nil {
+		return nil, fmt.Errorf("%w: %s", directory.ErrNotFound, key)
+	}
+	_, data, err := s.files.DownloadFile(ctx, key)
+	return data, err
+}
+
+func (s *nfsStore) Write(ctx context.Context, key string, data []byte) error {
+	return s.write(ctx, key, data, "")
+}
+
+// write stores data at key, handing the file to tenant unless it is empty
+func (s *nfsStore) write(ctx context.Context, key string, data []byte, tenant string) error {
+	var name, contentType string
+	var attrs map[string]string
+	var tags []string
+	if rec, err := s.files.metadata.Get(key); err == nil {
+		name, contentType, attrs, tags = rec.Name, rec.ContentType, rec.Metadata, rec.Tags
+	}
+	if tenant != "" && attrs["tenant"] != tenant {
+		attrs = maps.Clone(attrs)
+		if attrs == nil {
+			attrs = make(map[string]string, 1)
+		}
+		attrs["tenant"] = tenant
+	}
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(key))
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	_, err := s.files.UploadFileAt(ctx, key, name, data, contentType, attrs, tags)
+	return err
+}
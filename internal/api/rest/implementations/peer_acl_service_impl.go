@@ -0,0 +1,46 @@
+package implementations
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/acl"
+)
+
+type PeerACLServiceImpl struct {
+	server *fileserver.Server
+}
+
+func NewPeerACLService(server *fileserver.Server) services.PeerACLService {
+	return &PeerACLServiceImpl{server: server}
+}
+
+func (s *PeerACLServiceImpl) ListRules(ctx context.Context) []acl.Rule {
+	return s.server.PeerACL.Rules()
+}
+
+func (s *PeerACLServiceImpl) AddRule(ctx context.Context, req *requests.PeerACLRuleRequest, actor string) (acl.Rule, error) {
+	rule, err := s.server.PeerACL.AddRule(ctx, acl.Rule{
+		Action:   acl.Action(req.Action),
+		NodeID:   req.NodeID,
+		CIDR:     req.CIDR,
+		Identity: req.Identity,
+		Comment:  req.Comment,
+	}, actor)
+	if err != nil {
+		return acl.Rule{}, err
+	}
+	s.server.EnforcePeerACL()
+	return rule, nil
+}
+
+func (s *PeerACLServiceImpl) RemoveRule(ctx context.Context, id, actor string) error {
+	if err := s.server.PeerACL.RemoveRule(ctx, id, actor); err != nil {
+		return err
+	}
+	// Removing an allow rule can shrink an allowlist
+	s.server.EnforcePeerACL()
+	return nil
+}
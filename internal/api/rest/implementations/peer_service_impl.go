@@ -2,22 +2,69 @@ package implementations
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
+	"net"
+	"strconv"
 	"time"
 
 	"github.com/Skpow1234/Peervault/internal/api/rest/services"
 	"github.com/Skpow1234/Peervault/internal/api/rest/types"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
 )
 
 type PeerServiceImpl struct {
-	// TODO: Add peer management dependency
+	// server lists the peers of a real node; nil serves mock data
+	server *fileserver.Server
 }
 
 func NewPeerService() services.PeerService {
 	return &PeerServiceImpl{}
 }
 
+// NewPeerServiceWithFileServer returns a peer service listing the peers
+// connected to server, known by the node IDs they authenticated as
+func NewPeerServiceWithFileServer(server *fileserver.Server) services.PeerService {
+	return &PeerServiceImpl{server: server}
+}
+
+// nodePeer converts a connected peer of the node
+func nodePeer(info fileserver.PeerInfo, now time.Time) types.Peer {
+	peer := types.Peer{ID: info.ID, Address: info.Addr, Status: "connected", LastSeen: now, Metadata: map[string]string{}}
+	if host, port, err := net.SplitHostPort(info.Addr); err == nil {
+		peer.Address = host
+		peer.Port, _ = strconv.Atoi(port)
+	}
+	if info.PublicKey != nil {
+		peer.Metadata["public_key"] = hex.EncodeToString(info.PublicKey)
+	}
+	if info.Outbound {
+		peer.Metadata["direction"] = "outbound"
+	} else {
+		peer.Metadata["direction"] = "inbound"
+	}
+	if info.ProtocolVersion > 0 {
+		peer.Metadata["protocol_version"] = strconv.Itoa(int(info.ProtocolVersion))
+	}
+	if info.Free > 0 {
+		peer.Metadata["free_bytes"] = strconv.FormatInt(info.Free, 10)
+	}
+	if info.Full {
+		peer.Metadata["storage_full"] = "true"
+	}
+	return peer
+}
+
 func (s *PeerServiceImpl) ListPeers(ctx context.Context) ([]types.Peer, error) {
+	if s.server != nil {
+		now := time.Now()
+		peers := []types.Peer{}
+		for _, info := range s.server.Peers() {
+			peers = append(peers, nodePeer(info, now))
+		}
+		return peers, nil
+	}
+
 	// TODO: Implement actual peer listing
 	// return s.peerManager.ListPeers()
 
@@ -36,6 +83,16 @@ func (s *PeerServiceImpl) ListPeers(ctx context.Context) ([]types.Peer, error) {
 }
 
 func (s *PeerServiceImpl) GetPeer(ctx context.Context, peerID string) (*types.Peer, error) {
+	if s.server != nil {
+		for _, info := range s.server.Peers() {
+			if info.ID == peerID {
+				peer := nodePeer(info, time.Now())
+				return &peer, nil
+			}
+		}
+		return nil, fmt.Errorf("peer not found: %s", peerID)
+	}
+
 	// TODO: Implement actual peer retrieval
 	// return s.peerManager.GetPeer(peerID)
 
@@ -55,6 +112,19 @@ func (s *PeerServiceImpl) GetPeer(ctx context.Context, peerID string) (*types.Pe
 }
 
 func (s *PeerServiceImpl) AddPeer(ctx context.Context, peer types.Peer) (*types.Peer, error) {
+	if s.server != nil {
+		// The peer's ID is only known once its handshake completed
+		addr := net.JoinHostPort(peer.Address, strconv.Itoa(peer.Port))
+		if err := s.server.Transport.Dial(addr); err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+		}
+		peer.ID = addr
+		peer.Status = "connecting"
+		peer.CreatedAt = time.Now()
+		peer.LastSeen = peer.CreatedAt
+		return &peer, nil
+	}
+
 	// TODO: Implement actual peer addition
 	// return s.peerManager.AddPeer(peer)
 
@@ -67,6 +137,13 @@ func (s *PeerServiceImpl) AddPeer(ctx context.Context, peer types.Peer) (*types.
 }
 
 func (s *PeerServiceImpl) RemovePeer(ctx context.Context, peerID string) error {
+	if s.server != nil {
+		if !s.server.Disconnect(peerID) {
+			return fmt.Errorf("peer not found: %s", peerID)
+		}
+		return nil
+	}
+
 	// TODO: Implement actual peer removal
 	// return s.peerManager.RemovePeer(peerID)
 
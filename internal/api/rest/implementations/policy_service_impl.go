@@ -0,0 +1,24 @@
+package implementations
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/policy"
+)
+
+type PolicyServiceImpl struct {
+	engine *policy.Engine
+}
+
+func NewPolicyService(engine *policy.Engine) services.PolicyService {
+	return &PolicyServiceImpl{engine: engine}
+}
+
+func (s *PolicyServiceImpl) GetPolicy(ctx context.Context) (policy.Policy, bool, error) {
+	return s.engine.Policy(), s.engine.DryRun(), nil
+}
+
+func (s *PolicyServiceImpl) ListDecisions(ctx context.Context, limit int, denied bool) ([]policy.Decision, error) {
+	return s.engine.Decisions(limit, denied), nil
+}
@@ -0,0 +1,35 @@
+package implementations
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/sqlquery"
+)
+
+type QueryServiceImpl struct {
+	files *FileServiceImpl
+}
+
+// NewQueryService creates the SQL view of the metadata of a file service
+func NewQueryService(files services.FileService) (services.QueryService, error) {
+	impl, ok := files.(*FileServiceImpl)
+	if !ok {
+		return nil, errors.New("queries require the metadata-backed file service")
+	}
+	return &QueryServiceImpl{files: impl}, nil
+}
+
+func (s *QueryServiceImpl) Query(ctx context.Context, sql string, maxRows int) (*sqlquery.Result, error) {
+	tables := sqlquery.MetadataTables(s.files.metadata.List())
+	return sqlquery.Query(ctx, sql, tables, sqlquery.Options{MaxRows: maxRows})
+}
+
+func (s *QueryServiceImpl) ListTables(ctx context.Context) ([]sqlquery.Table, error) {
+	tables := sqlquery.MetadataTables(nil)
+	for i := range tables {
+		tables[i].Rows = nil
+	}
+	return tables, nil
+}
@@ -0,0 +1,44 @@
+package implementations
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/queue"
+)
+
+type QueueServiceImpl struct {
+	jobs *queue.Queue
+}
+
+func NewQueueService(jobs *queue.Queue) services.QueueService {
+	return &QueueServiceImpl{jobs: jobs}
+}
+
+func (s *QueueServiceImpl) GetStats(ctx context.Context) queue.Stats {
+	return s.jobs.Stats()
+}
+
+func (s *QueueServiceImpl) ListJobs(ctx context.Context, state queue.State, kind string) []queue.Job {
+	jobs := s.jobs.List(state, kind)
+	if jobs == nil {
+		jobs = []queue.Job{}
+	}
+	return jobs
+}
+
+func (s *QueueServiceImpl) GetJob(ctx context.Context, id string) (queue.Job, error) {
+	return s.jobs.Get(id)
+}
+
+func (s *QueueServiceImpl) RequeueJob(ctx context.Context, id string) (queue.Job, error) {
+	return s.jobs.Requeue(id)
+}
+
+func (s *QueueServiceImpl) RequeueDead(ctx context.Context, kind string) int {
+	return s.jobs.RequeueDead(kind)
+}
+
+func (s *QueueServiceImpl) DeleteJob(ctx context.Context, id string) error {
+	return s.jobs.Delete(id)
+}
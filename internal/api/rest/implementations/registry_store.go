@@ -0,0 +1,18 @@
+package implementations
+
+import (
+	"errors"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/registry"
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+)
+
+// NewRegistryStore returns the files of a file service for the container
+// registry, which reads and writes them the way the NFS gateway does
+func NewRegistryStore(files services.FileService) (registry.Store, error) {
+	impl, ok := files.(*FileServiceImpl)
+	if !ok {
+		return nil, errors.New("the container registry requires the metadata-backed file service")
+	}
+	return &nfsStore{fileDirectoryStore{files: impl}}, nil
+}
@@ -0,0 +1,24 @@
+package implementations
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+)
+
+type ReplicaServiceImpl struct {
+	server *fileserver.Server
+	files  services.FileService
+}
+
+func NewReplicaService(server *fileserver.Server, files services.FileService) services.ReplicaService {
+	return &ReplicaServiceImpl{server: server, files: files}
+}
+
+func (s *ReplicaServiceImpl) GetReplicas(ctx context.Context, key string) (fileserver.ReplicaReport, error) {
+	if _, err := s.files.GetFile(ctx, key); err != nil {
+		return fileserver.ReplicaReport{}, err
+	}
+	return s.server.Replicas(ctx, key)
+}
@@ -0,0 +1,51 @@
+package implementations
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/analytics"
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+)
+
+type ReportServiceImpl struct {
+	server *fileserver.Server
+}
+
+func NewReportService(server *fileserver.Server) services.ReportService {
+	return &ReportServiceImpl{server: server}
+}
+
+func (s *ReportServiceImpl) ListReports(ctx context.Context) []analytics.ReportInfo {
+	return s.server.Reports.List()
+}
+
+func (s *ReportServiceImpl) GetReport(ctx context.Context, name string) (analytics.ReportInfo, error) {
+	return s.server.Reports.Get(name)
+}
+
+func (s *ReportServiceImpl) PutReport(ctx context.Context, name string, req *requests.ReportRequest) (analytics.ReportInfo, error) {
+	return s.server.Reports.Put(analytics.Report{
+		Name:      name,
+		Dimension: req.Dimension,
+		Value:     req.Value,
+		Metrics:   req.Metrics,
+		Grouping:  req.Grouping,
+		Range:     req.Range,
+		Limit:     req.Limit,
+		Schedule:  req.Schedule,
+		Format:    req.Format,
+		Prefix:    req.Prefix,
+		Webhook:   req.Webhook,
+		Email:     req.Email,
+	})
+}
+
+func (s *ReportServiceImpl) DeleteReport(ctx context.Context, name string) error {
+	return s.server.Reports.Delete(name)
+}
+
+func (s *ReportServiceImpl) RunReport(ctx context.Context, name string) (*analytics.ReportRun, error) {
+	return s.server.RunReport(ctx, name)
+}
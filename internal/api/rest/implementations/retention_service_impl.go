@@ -0,0 +1,62 @@
+package implementations
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/retention"
+)
+
+type RetentionServiceImpl struct {
+	locks *retention.Manager
+	files services.FileService
+}
+
+func NewRetentionService(locks *retention.Manager, files services.FileService) services.RetentionService {
+	return &RetentionServiceImpl{locks: locks, files: files}
+}
+
+func (s *RetentionServiceImpl) GetLock(ctx context.Context, key string) (*retention.Lock, error) {
+	if _, err := s.files.GetFile(ctx, key); err != nil {
+		return nil, err
+	}
+	lock, ok := s.locks.Get(key)
+	if !ok {
+		lock = retention.Lock{Key: key}
+	}
+	return &lock, nil
+}
+
+func (s *RetentionServiceImpl) ListLocks(ctx context.Context) ([]retention.Lock, error) {
+	return s.locks.List(), nil
+}
+
+func (s *RetentionServiceImpl) UpdateLock(ctx context.Context, key string, req *requests.FileLockUpdateRequest, actor string) (*retention.Lock, error) {
+	if req.RetainUntil == "" && req.LegalHold == nil {
+		return nil, errors.New("nothing to update: set retain_until and/or legal_hold")
+	}
+	if _, err := s.files.GetFile(ctx, key); err != nil {
+		return nil, err
+	}
+
+	var lock retention.Lock
+	if req.RetainUntil != "" {
+		until, err := retention.ParseRetention(req.RetainUntil, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if lock, err = s.locks.SetRetention(ctx, key, until, actor); err != nil {
+			return nil, err
+		}
+	}
+	if req.LegalHold != nil {
+		var err error
+		if lock, err = s.locks.SetLegalHold(ctx, key, *req.LegalHold, actor); err != nil {
+			return nil, err
+		}
+	}
+	return &lock, nil
+}
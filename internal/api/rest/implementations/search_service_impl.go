@@ -0,0 +1,41 @@
+package implementations
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/search"
+)
+
+type SearchServiceImpl struct {
+	index *search.Index
+}
+
+func NewSearchService(index *search.Index) services.SearchService {
+	return &SearchServiceImpl{index: index}
+}
+
+func (s *SearchServiceImpl) Search(ctx context.Context, query search.Query) (*search.Results, error) {
+	results := s.index.Search(query)
+	return &results, nil
+}
+
+func (s *SearchServiceImpl) Rebuild(ctx context.Context) error {
+	if s.index.Stats().Rebuilding {
+		return search.ErrRebuildInProgress
+	}
+
+	// Rebuilds outlive the request that started them
+	go func() {
+		if err := s.index.Rebuild(context.Background()); err != nil {
+			slog.Error("search index rebuild failed", "error", err)
+		}
+	}()
+	return nil
+}
+
+func (s *SearchServiceImpl) Status(ctx context.Context) (*search.Stats, error) {
+	stats := s.index.Stats()
+	return &stats, nil
+}
@@ -0,0 +1,33 @@
+package implementations
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/sftp"
+)
+
+// sftpStore adapts FileServiceImpl to sftp.Store the way nfsStore does;
+// files uploaded over SFTP belong to the uploader's tenant
+type sftpStore struct {
+	nfsStore
+}
+
+// NewSFTPStore returns the files of a file service for the SFTP server
+func NewSFTPStore(files services.FileService) (sftp.Store, error) {
+	impl, ok := files.(*FileServiceImpl)
+	if !ok {
+		return nil, errors.New("the SFTP server requires the metadata-backed file service")
+	}
+	return &sftpStore{nfsStore{fileDirectoryStore{files: impl}}}, nil
+}
+
+func (s *sftpStore) Write(ctx context.Context, key string, content io.Reader, tenant string) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	return s.write(ctx, key, data, tenant)
+}
@@ -0,0 +1,92 @@
+package implementations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/policy"
+	"github.com/Skpow1234/Peervault/internal/sharing"
+)
+
+type ShareServiceImpl struct {
+	links  *sharing.Manager
+	files  services.FileService
+	policy *policy.Engine // nil without a policy
+}
+
+// NewShareService returns a share service; rules may deny links when rules
+// is not nil
+func NewShareService(links *sharing.Manager, files services.FileService, rules *policy.Engine) services.ShareService {
+	return &ShareServiceImpl{links: links, files: files, policy: rules}
+}
+
+func (s *ShareServiceImpl) CreateLink(ctx context.Context, req *requests.ShareLinkCreateRequest, createdBy string) (*sharing.Link, string, error) {
+	file, err := s.files.GetFile(ctx, req.Key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ttl, err := sharing.ParseTTL(req.ExpiresIn)
+	if err != nil {
+		return nil, "", err
+	}
+	if s.policy != nil {
+		err := s.policy.Check(policy.Input{
+			Operation:   policy.OpShare,
+			Key:         file.Key,
+			Size:        file.Size,
+			ContentType: file.ContentType,
+			Tenant:      file.Metadata["tenant"],
+			Tags:        file.Tags,
+			Metadata:    file.Metadata,
+			Share: policy.Share{
+				TTLSeconds:   int64(ttl.Seconds()),
+				MaxDownloads: req.MaxDownloads,
+				Password:     req.Password != "",
+				CreatedBy:    createdBy,
+			},
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	return s.links.Create(sharing.CreateOptions{
+		Key:          req.Key,
+		TTL:          ttl,
+		MaxDownloads: req.MaxDownloads,
+		Password:     req.Password,
+		CreatedBy:    createdBy,
+	})
+}
+
+func (s *ShareServiceImpl) ListLinks(ctx context.Context, key string) ([]sharing.Link, error) {
+	return s.links.List(key), nil
+}
+
+func (s *ShareServiceImpl) GetLink(ctx context.Context, id string) (*sharing.Link, error) {
+	return s.links.Get(id)
+}
+
+func (s *ShareServiceImpl) RevokeLink(ctx context.Context, id, revokedBy string) (*sharing.Link, error) {
+	return s.links.Revoke(ctx, id, revokedBy)
+}
+
+func (s *ShareServiceImpl) LinkURL(link *sharing.Link) string {
+	return s.links.URL(link)
+}
+
+func (s *ShareServiceImpl) OpenLink(ctx context.Context, access sharing.Access) (*types.File, []byte, error) {
+	link, err := s.links.Authorize(ctx, access)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, data, err := s.files.DownloadFile(ctx, link.Key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("shared file unavailable: %w", err)
+	}
+	return file, data, nil
+}
@@ -0,0 +1,140 @@
+package implementations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/backup"
+	"github.com/Skpow1234/Peervault/internal/scan"
+	"github.com/Skpow1234/Peervault/internal/snapshot"
+)
+
+type SnapshotServiceImpl struct {
+	snaps   *snapshot.Manager
+	backups *backup.Scheduler
+}
+
+// NewSnapshotService creates a snapshot service; backups may be nil when
+// no backup jobs can run
+func NewSnapshotService(snaps *snapshot.Manager, backups *backup.Scheduler) services.SnapshotService {
+	return &SnapshotServiceImpl{snaps: snaps, backups: backups}
+}
+
+// NewSnapshotManager creates a snapshot manager over the files of a file
+// service. From then on the service preserves the content snapshots
+// recorded before overwriting or deleting it.
+func NewSnapshotManager(files services.FileService, cfg *snapshot.Config, logger *slog.Logger) (*snapshot.Manager, error) {
+	impl, ok := files.(*FileServiceImpl)
+	if !ok {
+		return nil, errors.New("snapshots require the metadata-backed file service")
+	}
+	if cfg == nil {
+		cfg = &snapshot.Config{}
+	}
+	store := &fileBackupStore{files: impl}
+	snaps, err := snapshot.NewManager(snapshot.Options{
+		Source:    store,
+		Sink:      store,
+		Path:      cfg.Path,
+		Schedules: cfg.Schedules,
+		Logger:    logger,
+	})
+	if err != nil {
+		return nil, err
+	}
+	impl.content = &snapshotContent{contentStore: impl.content, snaps: snaps}
+	return snaps, nil
+}
+
+func (s *SnapshotServiceImpl) ListSnapshots(ctx context.Context) ([]snapshot.Snapshot, error) {
+	return s.snaps.List(), nil
+}
+
+func (s *SnapshotServiceImpl) CreateSnapshot(ctx context.Context, namespace, name string) (*snapshot.Snapshot, error) {
+	return s.snaps.Create(ctx, namespace, name)
+}
+
+func (s *SnapshotServiceImpl) GetSnapshot(ctx context.Context, id string) (*snapshot.Snapshot, error) {
+	return s.snaps.Get(id)
+}
+
+func (s *SnapshotServiceImpl) DeleteSnapshot(ctx context.Context, id string) error {
+	return s.snaps.Delete(id)
+}
+
+func (s *SnapshotServiceImpl) DownloadFile(ctx context.Context, id, key string) (*backup.Entry, []byte, error) {
+	rc, entry, err := s.snaps.Open(ctx, id, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = rc.Close() }()
+	data, err := io.ReadAll(rc)
+	return entry, data, err
+}
+
+func (s *SnapshotServiceImpl) Diff(ctx context.Context, from, to string) (*snapshot.Diff, error) {
+	return s.snaps.Diff(ctx, from, to)
+}
+
+func (s *SnapshotServiceImpl) Clone(ctx context.Context, id, prefix string, overwrite bool) (*snapshot.CloneReport, error) {
+	return s.snaps.Clone(ctx, id, prefix, overwrite)
+}
+
+func (s *SnapshotServiceImpl) Backup(ctx context.Context, id, job string, typ backup.BackupType) (*backup.Snapshot, error) {
+	if s.backups == nil {
+		return nil, fmt.Errorf("%w: %s", backup.ErrJobNotFound, job)
+	}
+	source, err := s.snaps.Source(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.backups.RunFrom(ctx, job, typ, source)
+}
+
+// snapshotContent preserves the content snapshots recorded before it is
+// overwritten or deleted
+type snapshotContent struct {
+	contentStore
+	snaps *snapshot.Manager
+}
+
+func (c *snapshotContent) Put(ctx context.Context, key string, data []byte) (*scan.Report, error) {
+	if err := c.preserve(ctx, key); err != nil {
+		return nil, err
+	}
+	return c.contentStore.Put(ctx, key, data)
+}
+
+func (c *snapshotContent) Delete(ctx context.Context, key string) error {
+	if err := c.preserve(ctx, key); err != nil {
+		return err
+	}
+	return c.contentStore.Delete(ctx, key)
+}
+
+func (c *snapshotContent) Copy(ctx context.Context, from, to string) error {
+	if err := c.preserve(ctx, to); err != nil {
+		return err
+	}
+	return c.contentStore.Copy(ctx, from, to)
+}
+
+func (c *snapshotContent) Rename(ctx context.Context, from, to string) error {
+	if err := c.preserve(ctx, from); err != nil {
+		return err
+	}
+	if err := c.preserve(ctx, to); err != nil {
+		return err
+	}
+	return c.contentStore.Rename(ctx, from, to)
+}
+
+func (c *snapshotContent) preserve(ctx context.Context, key string) error {
+	return c.snaps.Preserve(ctx, key, func() ([]byte, error) {
+		return c.contentStore.Get(ctx, key)
+	})
+}
@@ -0,0 +1,21 @@
+package implementations
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/peer"
+)
+
+type TopologyServiceImpl struct {
+	server *fileserver.Server
+}
+
+func NewTopologyService(server *fileserver.Server) services.TopologyService {
+	return &TopologyServiceImpl{server: server}
+}
+
+func (s *TopologyServiceImpl) GetTopology(ctx context.Context) (peer.Topology, error) {
+	return s.server.Topology(), nil
+}
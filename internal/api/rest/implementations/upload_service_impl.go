@@ -0,0 +1,80 @@
+package implementations
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/uploads"
+)
+
+type UploadServiceImpl struct {
+	uploads *uploads.Manager
+	files   services.FileService
+}
+
+func NewUploadService(uploads *uploads.Manager, files services.FileService) services.UploadService {
+	return &UploadServiceImpl{uploads: uploads, files: files}
+}
+
+func (s *UploadServiceImpl) CreateUpload(ctx context.Context, req *requests.UploadCreateRequest) (*uploads.Upload, error) {
+	return s.uploads.Create(uploads.CreateOptions{
+		Name:        req.Name,
+		ContentType: req.ContentType,
+		Size:        req.Size,
+		PartSize:    req.PartSize,
+		Tags:        req.Tags,
+		Metadata:    req.Metadata,
+	})
+}
+
+// GetUpload returns an upload of the API. Streamed uploads belong to the
+// container registry and are not found.
+func (s *UploadServiceImpl) GetUpload(ctx context.Context, id string) (*uploads.Upload, error) {
+	upload, err := s.uploads.Get(id)
+	if err == nil && upload.Streamed {
+		return nil, uploads.ErrNotFound
+	}
+	return upload, err
+}
+
+func (s *UploadServiceImpl) UploadPart(ctx context.Context, id string, part int, r io.Reader, checksum string) (string, error) {
+	return s.uploads.PutPart(id, part, r, checksum)
+}
+
+func (s *UploadServiceImpl) CompleteUpload(ctx context.Context, id string) (*types.File, error) {
+	upload, err := s.GetUpload(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	content, err := s.uploads.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(content)
+	if closeErr := content.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to assemble upload: %w", err)
+	}
+
+	file, err := s.files.UploadFile(ctx, upload.Name, data, upload.ContentType, upload.Metadata, upload.Tags)
+	if err != nil {
+		return nil, err
+	}
+	// The file is stored; a leftover staging directory is only wasted space
+	// until it expires
+	_ = s.uploads.Delete(id)
+	return file, nil
+}
+
+func (s *UploadServiceImpl) AbortUpload(ctx context.Context, id string) error {
+	if _, err := s.GetUpload(ctx, id); err != nil {
+		return err
+	}
+	return s.uploads.Delete(id)
+}
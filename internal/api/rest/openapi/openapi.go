@@ -0,0 +1,392 @@
+// Package openapi builds the OpenAPI 3.1 description of the REST API from
+// the routes the server registers. Request and response schemas are derived
+// from the Go types the handlers decode and encode, so the document cannot
+// drift from the implementation.
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/Skpow1234/Peervault/internal/api/apierror"
+)
+
+// Version is the OpenAPI version of generated documents
+const Version = "3.1.0"
+
+// Operation describes an endpoint. Path uses net/http pattern syntax, so
+// {name} and {name...} wildcards become path parameters and a trailing {$}
+// is dropped.
+type Operation struct {
+	Method      string
+	Path        string
+	ID          string
+	Summary     string
+	Description string
+	Tag         string
+	// Public operations need no bearer token
+	Public bool
+	// Params lists query and header parameters. Path parameters not listed
+	// are documented as strings.
+	Params    []Param
+	Body      *Body
+	Responses []Response
+}
+
+// Param is a query, header or path parameter
+type Param struct {
+	Name        string
+	In          string
+	Description string
+	// Type is a JSON Schema type; defaults to string
+	Type     string
+	Required bool
+}
+
+// Query describes an optional query parameter
+func Query(name, typ, description string) Param {
+	return Param{Name: name, In: "query", Type: typ, Description: description}
+}
+
+// RequiredQuery describes a mandatory query parameter
+func RequiredQuery(name, typ, description string) Param {
+	return Param{Name: name, In: "query", Type: typ, Description: description, Required: true}
+}
+
+// Header describes an optional request header
+func Header(name, description string) Param {
+	return Param{Name: name, In: "header", Description: description}
+}
+
+// Path describes a path parameter that is not a string
+func Path(name, typ, description string) Param {
+	return Param{Name: name, In: "path", Type: typ, Description: description, Required: true}
+}
+
+// Body is a request body. Its schema is Schema when set, otherwise derived
+// from the type of Value.
+type Body struct {
+	ContentType string
+	Value       interface{}
+	Schema      *Schema
+}
+
+// JSONBody is a JSON request body shaped like v
+func JSONBody(v interface{}) *Body {
+	return &Body{ContentType: "application/json", Value: v}
+}
+
+// BinaryBody is a raw request body
+func BinaryBody() *Body {
+	return &Body{ContentType: "application/octet-stream", Schema: &Schema{Type: "string", Format: "binary"}}
+}
+
+// FormBody is a multipart/form-data request body with the given fields
+func FormBody(fields map[string]*Schema, required ...string) *Body {
+	return &Body{ContentType: "multipart/form-data", Schema: &Schema{Type: "object", Properties: fields, Required: required}}
+}
+
+// Response is a possible response of an operation
+type Response struct {
+	Status      int
+	Description string
+	ContentType string
+	Value       interface{}
+	Schema      *Schema
+}
+
+// JSON is a response with a JSON body shaped like v
+func JSON(status int, description string, v interface{}) Response {
+	return Response{Status: status, Description: description, ContentType: "application/json", Value: v}
+}
+
+// Empty is a response without a body
+func Empty(status int, description string) Response {
+	return Response{Status: status, Description: description}
+}
+
+// Binary is a response carrying file content
+func Binary(status int, description string) Response {
+	return Response{Status: status, Description: description, ContentType: "application/octet-stream", Schema: &Schema{Type: "string", Format: "binary"}}
+}
+
+// Error is an error response in the shared error model
+func Error(status int, description string) Response {
+	return JSON(status, description, apierror.Error{})
+}
+
+// Document is an OpenAPI document
+type Document struct {
+	OpenAPI    string                `json:"openapi" yaml:"openapi"`
+	Info       Info                  `json:"info" yaml:"info"`
+	Servers    []Server              `json:"servers,omitempty" yaml:"servers,omitempty"`
+	Tags       []Tag                 `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Security   []SecurityRequirement `json:"security,omitempty" yaml:"security,omitempty"`
+	Paths      map[string]PathItem   `json:"paths" yaml:"paths"`
+	Components Components            `json:"components" yaml:"components"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title" yaml:"title"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Version     string `json:"version" yaml:"version"`
+}
+
+// Server is a base URL of the API
+type Server struct {
+	URL         string `json:"url" yaml:"url"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// Tag groups operations
+type Tag struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// SecurityRequirement names the security schemes an operation accepts
+type SecurityRequirement map[string][]string
+
+// PathItem maps lower case HTTP methods to the operations of a path
+type PathItem map[string]*OperationObject
+
+// OperationObject is an operation as it appears in the document
+type OperationObject struct {
+	OperationID string                    `json:"operationId" yaml:"operationId"`
+	Summary     string                    `json:"summary,omitempty" yaml:"summary,omitempty"`
+	Description string                    `json:"description,omitempty" yaml:"description,omitempty"`
+	Tags        []string                  `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Security    *[]SecurityRequirement    `json:"security,omitempty" yaml:"security,omitempty"`
+	Parameters  []Parameter               `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	RequestBody *RequestBody              `json:"requestBody,omitempty" yaml:"requestBody,omitempty"`
+	Responses   map[string]ResponseObject `json:"responses" yaml:"responses"`
+}
+
+// Parameter is a parameter as it appears in the document
+type Parameter struct {
+	Name        string  `json:"name" yaml:"name"`
+	In          string  `json:"in" yaml:"in"`
+	Description string  `json:"description,omitempty" yaml:"description,omitempty"`
+	Required    bool    `json:"required,omitempty" yaml:"required,omitempty"`
+	Schema      *Schema `json:"schema" yaml:"schema"`
+}
+
+// RequestBody is a request body as it appears in the document
+type RequestBody struct {
+	Required bool                 `json:"required" yaml:"required"`
+	Content  map[string]MediaType `json:"content" yaml:"content"`
+}
+
+// ResponseObject is a response as it appears in the document
+type ResponseObject struct {
+	Description string               `json:"description" yaml:"description"`
+	Content     map[string]MediaType `json:"content,omitempty" yaml:"content,omitempty"`
+}
+
+// MediaType is the schema of a body in one content type
+type MediaType struct {
+	Schema *Schema `json:"schema" yaml:"schema"`
+}
+
+// Components holds the named schemas operations refer to
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas,omitempty" yaml:"schemas,omitempty"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty" yaml:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes how clients authenticate
+type SecurityScheme struct {
+	Type        string `json:"type" yaml:"type"`
+	Scheme      string `json:"scheme,omitempty" yaml:"scheme,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// bearerScheme is the name of the bearer token security scheme
+const bearerScheme = "bearerAuth"
+
+var pathParam = regexp.MustCompile(`\{([^}.$]+)(\.\.\.)?\}`)
+
+// Build assembles the document describing ops
+func Build(info Info, servers []Server, tags []Tag, ops []Operation) (*Document, error) {
+	doc := &Document{
+		OpenAPI:  Version,
+		Info:     info,
+		Servers:  servers,
+		Tags:     tags,
+		Security: []SecurityRequirement{{bearerScheme: {}}},
+		Paths:    make(map[string]PathItem),
+		Components: Components{
+			SecuritySchemes: map[string]SecurityScheme{
+				bearerScheme: {Type: "http", Scheme: "bearer", Description: "The API token of the server"},
+			},
+		},
+	}
+	gen := newGenerator()
+	ids := make(map[string]bool)
+
+	var errs []error
+	for _, op := range ops {
+		obj, err := buildOperation(gen, op)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", op.Method, op.Path, err))
+			continue
+		}
+		if ids[op.ID] {
+			errs = append(errs, fmt.Errorf("%s %s: duplicate operation ID %q", op.Method, op.Path, op.ID))
+			continue
+		}
+		ids[op.ID] = true
+
+		path := pathParam.ReplaceAllString(strings.TrimSuffix(op.Path, "{$}"), "{$1}")
+		item := doc.Paths[path]
+		if item == nil {
+			item = make(PathItem)
+			doc.Paths[path] = item
+		}
+		method := strings.ToLower(op.Method)
+		if item[method] != nil {
+			errs = append(errs, fmt.Errorf("%s %s: described twice", op.Method, op.Path))
+			continue
+		}
+		item[method] = obj
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	doc.Components.Schemas = gen.schemas
+	return doc, nil
+}
+
+func buildOperation(gen *generator, op Operation) (*OperationObject, error) {
+	switch op.Method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodHead:
+	default:
+		return nil, fmt.Errorf("unsupported method")
+	}
+	if op.ID == "" {
+		return nil, errors.New("missing operation ID")
+	}
+	if len(op.Responses) == 0 {
+		return nil, errors.New("no responses")
+	}
+
+	obj := &OperationObject{
+		OperationID: op.ID,
+		Summary:     op.Summary,
+		Description: op.Description,
+		Responses:   make(map[string]ResponseObject),
+	}
+	if op.Tag != "" {
+		obj.Tags = []string{op.Tag}
+	}
+	if op.Public {
+		obj.Security = &[]SecurityRequirement{}
+	}
+
+	// Path parameters come first, in the order they appear in the path
+	documented := make(map[string]Param)
+	for _, p := range op.Params {
+		if p.In == "path" {
+			documented[p.Name] = p
+		}
+	}
+	for _, match := range pathParam.FindAllStringSubmatch(op.Path, -1) {
+		p, ok := documented[match[1]]
+		if !ok {
+			p = Param{Name: match[1], In: "path", Required: true}
+		}
+		delete(documented, match[1])
+		obj.Parameters = append(obj.Parameters, parameter(p))
+	}
+	for name := range documented {
+		return nil, fmt.Errorf("path parameter %q is not in the path", name)
+	}
+	for _, p := range op.Params {
+		switch p.In {
+		case "path":
+		case "query", "header":
+			obj.Parameters = append(obj.Parameters, parameter(p))
+		default:
+			return nil, fmt.Errorf("parameter %q has unsupported location %q", p.Name, p.In)
+		}
+	}
+
+	if op.Body != nil {
+		schema := op.Body.Schema
+		if schema == nil {
+			schema = gen.schemaOf(op.Body.Value)
+		}
+		obj.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{op.Body.ContentType: {Schema: schema}}}
+	}
+
+	for _, r := range op.Responses {
+		code := strconv.Itoa(r.Status)
+		if _, ok := obj.Responses[code]; ok {
+			return nil, fmt.Errorf("response %s described twice", code)
+		}
+		resp := ResponseObject{Description: r.Description}
+		if resp.Description == "" {
+			resp.Description = http.StatusText(r.Status)
+		}
+		if r.ContentType != "" {
+			schema := r.Schema
+			if schema == nil {
+				schema = gen.schemaOf(r.Value)
+			}
+			resp.Content = map[string]MediaType{r.ContentType: {Schema: schema}}
+		}
+		obj.Responses[code] = resp
+	}
+	return obj, nil
+}
+
+func parameter(p Param) Parameter {
+	typ := p.Type
+	if typ == "" {
+		typ = "string"
+	}
+	return Parameter{Name: p.Name, In: p.In, Description: p.Description, Required: p.Required, Schema: &Schema{Type: typ}}
+}
+
+// JSON encodes the document as indented JSON
+func (d *Document) JSON() ([]byte, error) {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// YAML encodes the document as YAML
+func (d *Document) YAML() ([]byte, error) {
+	return yaml.Marshal(d)
+}
+
+// EachOperation calls fn for every operation, sorted by path and method
+func (d *Document) EachOperation(fn func(method, path string, op *OperationObject)) {
+	paths := make([]string, 0, len(d.Paths))
+	for path := range d.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		item := d.Paths[path]
+		methods := make([]string, 0, len(item))
+		for method := range item {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			fn(strings.ToUpper(method), path, item[method])
+		}
+	}
+}
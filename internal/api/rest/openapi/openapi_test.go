@@ -0,0 +1,169 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testItem struct {
+	Name    string            `json:"name"`
+	Size    int64             `json:"size,omitempty"`
+	Created time.Time         `json:"created_at"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Data    []byte            `json:"data,omitempty"`
+	Parent  *testItem         `json:"parent,omitempty"`
+	secret  string
+	Skipped string `json:"-"`
+	testEmbedded
+}
+
+type testEmbedded struct {
+	Owner string `json:"owner"`
+}
+
+type testList struct {
+	Items []testItem `json:"items"`
+}
+
+func buildTestDoc(t *testing.T, ops ...Operation) *Document {
+	t.Helper()
+	doc, err := Build(Info{Title: "Test", Version: "1"}, nil, nil, ops)
+	require.NoError(t, err)
+	return doc
+}
+
+func TestBuildDerivesSchemas(t *testing.T) {
+	doc := buildTestDoc(t, Operation{
+		Method: "GET", Path: "/items", ID: "listItems",
+		Responses: []Response{JSON(http.StatusOK, "Items", testList{})},
+	})
+	assert.Equal(t, Version, doc.OpenAPI)
+
+	list := doc.Components.Schemas["testList"]
+	require.NotNil(t, list)
+	assert.Equal(t, "array", list.Properties["items"].Type)
+	assert.Equal(t, RefPrefix+"testItem", list.Properties["items"].Items.Ref)
+
+	item := doc.Components.Schemas["testItem"]
+	require.NotNil(t, item)
+	assert.ElementsMatch(t, []string{"name", "created_at", "owner"}, item.Required)
+	assert.Equal(t, "date-time", item.Properties["created_at"].Format)
+	assert.Equal(t, "int64", item.Properties["size"].Format)
+	assert.Equal(t, "string", item.Properties["labels"].AdditionalProperties.Type)
+	assert.Equal(t, "base64", item.Properties["data"].ContentEncoding)
+	assert.Equal(t, RefPrefix+"testItem", item.Properties["parent"].Ref)
+	assert.NotContains(t, item.Properties, "secret")
+	assert.NotContains(t, item.Properties, "Skipped")
+	assert.Contains(t, item.Properties, "owner")
+}
+
+func TestBuildOperations(t *testing.T) {
+	doc := buildTestDoc(t,
+		Operation{
+			Method: "PUT", Path: "/items/{id}/parts/{part}", ID: "putPart", Public: true,
+			Params:    []Param{Path("part", "integer", "Part number"), Query("force", "boolean", "")},
+			Body:      BinaryBody(),
+			Responses: []Response{Empty(http.StatusNoContent, ""), Error(http.StatusNotFound, "Missing")},
+		},
+		Operation{
+			Method: "GET", Path: "/files/{key...}", ID: "getFile",
+			Responses: []Response{Binary(http.StatusOK, "Content")},
+		},
+		Operation{
+			Method: "GET", Path: "/files/{$}", ID: "getRoot",
+			Responses: []Response{Empty(http.StatusOK, "")},
+		},
+	)
+
+	op := doc.Paths["/items/{id}/parts/{part}"]["put"]
+	require.NotNil(t, op)
+	require.Len(t, op.Parameters, 3)
+	assert.Equal(t, "id", op.Parameters[0].Name)
+	assert.Equal(t, "string", op.Parameters[0].Schema.Type)
+	assert.Equal(t, "part", op.Parameters[1].Name)
+	assert.Equal(t, "integer", op.Parameters[1].Schema.Type)
+	assert.Equal(t, "query", op.Parameters[2].In)
+	require.NotNil(t, op.Security)
+	assert.Empty(t, *op.Security)
+	assert.Equal(t, "No Content", op.Responses["204"].Description)
+	assert.Nil(t, op.Responses["204"].Content)
+	assert.Contains(t, op.Responses["404"].Content, "application/json")
+
+	// Rest wildcards are single path parameters in OpenAPI
+	get := doc.Paths["/files/{key}"]["get"]
+	require.NotNil(t, get)
+	assert.Nil(t, get.Security)
+	assert.Equal(t, "key", get.Parameters[0].Name)
+
+	// {$} only anchors the pattern
+	root := doc.Paths["/files/"]["get"]
+	require.NotNil(t, root)
+	assert.Empty(t, root.Parameters)
+}
+
+func TestBuildRejectsInvalidOperations(t *testing.T) {
+	ok := []Response{Empty(http.StatusOK, "")}
+	tests := map[string][]Operation{
+		"method":       {{Method: "BREW", Path: "/a", ID: "a", Responses: ok}},
+		"missing ID":   {{Method: "GET", Path: "/a", Responses: ok}},
+		"no responses": {{Method: "GET", Path: "/a", ID: "a"}},
+		"duplicate ID": {
+			{Method: "GET", Path: "/a", ID: "a", Responses: ok},
+			{Method: "GET", Path: "/b", ID: "a", Responses: ok},
+		},
+		"duplicate route": {
+			{Method: "GET", Path: "/a", ID: "a", Responses: ok},
+			{Method: "GET", Path: "/a", ID: "b", Responses: ok},
+		},
+		"unknown path parameter": {{Method: "GET", Path: "/a", ID: "a", Params: []Param{Path("id", "string", "")}, Responses: ok}},
+		"parameter location":     {{Method: "GET", Path: "/a", ID: "a", Params: []Param{{Name: "c", In: "cookie"}}, Responses: ok}},
+	}
+	for name, ops := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Build(Info{}, nil, nil, ops)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEncodings(t *testing.T) {
+	doc := buildTestDoc(t, Operation{
+		Method: "POST", Path: "/items", ID: "createItem",
+		Body:      JSONBody(testItem{}),
+		Responses: []Response{JSON(http.StatusCreated, "Created", testItem{})},
+	})
+
+	data, err := doc.JSON()
+	require.NoError(t, err)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, Version, decoded["openapi"])
+
+	yamlData, err := doc.YAML()
+	require.NoError(t, err)
+	assert.Contains(t, string(yamlData), "$ref: '#/components/schemas/testItem'")
+}
+
+func TestExample(t *testing.T) {
+	doc := buildTestDoc(t, Operation{
+		Method: "GET", Path: "/items", ID: "listItems",
+		Responses: []Response{JSON(http.StatusOK, "Items", testList{})},
+	})
+	schema := doc.Paths["/items"]["get"].Responses["200"].Content["application/json"].Schema
+
+	example, ok := doc.Example(schema).(map[string]interface{})
+	require.True(t, ok)
+	items, ok := example["items"].([]interface{})
+	require.True(t, ok)
+	require.Len(t, items, 1)
+	item := items[0].(map[string]interface{})
+	assert.Equal(t, "string", item["name"])
+	assert.Equal(t, 0, item["size"])
+	// The recursive parent stops at the first repetition
+	assert.Nil(t, item["parent"])
+}
@@ -3,12 +3,12 @@ package ratelimit
 import (
 	"fmt"
 	"math"
-	"net"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/Skpow1234/Peervault/internal/api/rest/versioning"
+	"github.com/Skpow1234/Peervault/internal/clientip"
 )
 
 // Algorithm represents the rate limiting algorithm to use
@@ -249,10 +249,7 @@ func (rl *RateLimiter) cleanup() {
 // getClientKey generates a unique key for the client
 func (rl *RateLimiter) getClientKey(r *http.Request, version versioning.APIVersion) string {
 	// Use IP address as primary key
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		ip = r.RemoteAddr
-	}
+	ip := clientip.FromRequest(r)
 
 	// Include API version in key for version-specific rate limiting
 	return fmt.Sprintf("%s:v%s", ip, version.String())
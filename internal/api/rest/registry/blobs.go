@@ -0,0 +1,324 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/uploads"
+)
+
+// algorithms are the digest algorithms accepted, by name
+var algorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// digest identifies content by its hash, as "sha256:<hex>"
+type digest struct {
+	algorithm string
+	hex       string
+}
+
+func (d digest) String() string {
+	return d.algorithm + ":" + d.hex
+}
+
+// parseDigest parses a digest of a supported algorithm
+func parseDigest(s string) (digest, error) {
+	algorithm, encoded, _ := strings.Cut(s, ":")
+	newHash, ok := algorithms[algorithm]
+	if !ok {
+		return digest{}, fmt.Errorf("unsupported digest %q", s)
+	}
+	if len(encoded) != 2*newHash().Size() || strings.ToLower(encoded) != encoded {
+		return digest{}, fmt.Errorf("invalid digest %q", s)
+	}
+	if _, err := hex.DecodeString(encoded); err != nil {
+		return digest{}, fmt.Errorf("invalid digest %q", s)
+	}
+	return digest{algorithm: algorithm, hex: encoded}, nil
+}
+
+// digestOf returns the digest of data with the algorithm of d
+func digestOf(algorithm string, data []byte) digest {
+	h := algorithms[algorithm]()
+	h.Write(data)
+	return digest{algorithm: algorithm, hex: hex.EncodeToString(h.Sum(nil))}
+}
+
+// errTooLarge is returned for blobs over MaxBlobSize
+var errTooLarge = errors.New("blob too large")
+
+// limitReader fails with errTooLarge once more than n bytes are read
+type limitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, errTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, errTooLarge
+	}
+	return n, err
+}
+
+// getBlob sends the blob with the digest
+func (g *Registry) getBlob(w http.ResponseWriter, r *http.Request, s string) {
+	d, err := parseDigest(s)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeDigestInvalid, err.Error())
+		return
+	}
+	size, err := g.stat(r.Context(), g.blobKey(d))
+	if notFound(err) {
+		writeError(w, http.StatusNotFound, codeBlobUnknown, "blob unknown to registry")
+		return
+	}
+	if err != nil {
+		g.internalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Docker-Content-Digest", d.String())
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", `"`+d.String()+`"`)
+	// Blobs never change
+	w.Header().Set("Cache-Control", "max-age=31536000")
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		return
+	}
+	data, err := g.store.Read(r.Context(), g.blobKey(d))
+	if err != nil {
+		g.internalError(w, r, err)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// putBlob stores data as the blob with digest d, unless it is stored
+func (g *Registry) putBlob(r *http.Request, d digest, data []byte) error {
+	if _, err := g.stat(r.Context(), g.blobKey(d)); err == nil {
+		return nil
+	}
+	return g.store.Write(r.Context(), g.blobKey(d), data)
+}
+
+// readBody reads the body of a request, up to MaxBlobSize bytes
+func (g *Registry) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	data, err := io.ReadAll(&limitReader{r: r.Body, n: g.config.MaxBlobSize})
+	switch {
+	case errors.Is(err, errTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, codeSizeInvalid, fmt.Sprintf("blobs are limited to %d bytes", g.config.MaxBlobSize))
+		return nil, false
+	case err != nil:
+		writeError(w, http.StatusBadRequest, codeBlobUploadInvalid, err.Error())
+		return nil, false
+	}
+	return data, true
+}
+
+// blobCreated answers that the blob with digest d is in the repository
+func blobCreated(w http.ResponseWriter, name string, d digest) {
+	w.Header().Set("Location", PathPrefix+name+"/blobs/"+d.String())
+	w.Header().Set("Docker-Content-Digest", d.String())
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// startUpload mounts a blob of another repository, stores a blob sent in
+// one request or starts an upload in chunks
+func (g *Registry) startUpload(w http.ResponseWriter, r *http.Request, name string) {
+	q := r.URL.Query()
+	// Blobs are shared by every repository, so mounting one only checks
+	// it exists; otherwise the client uploads it
+	if mount := q.Get("mount"); mount != "" {
+		if d, err := parseDigest(mount); err == nil {
+			if _, err := g.stat(r.Context(), g.blobKey(d)); err == nil {
+				blobCreated(w, name, d)
+				return
+			}
+		}
+	}
+
+	if s := q.Get("digest"); s != "" {
+		d, err := parseDigest(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeDigestInvalid, err.Error())
+			return
+		}
+		data, ok := g.readBody(w, r)
+		if !ok {
+			return
+		}
+		g.storeBlob(w, r, name, d, data)
+		return
+	}
+
+	upload, err := g.uploads.Create(uploads.CreateOptions{
+		Name:     name,
+		Streamed: true,
+		Metadata: map[string]string{"repository": name},
+	})
+	if err != nil {
+		g.internalError(w, r, err)
+		return
+	}
+	uploadAccepted(w, name, upload)
+}
+
+// uploadAccepted answers with where and how far an upload is
+func uploadAccepted(w http.ResponseWriter, name string, upload *uploads.Upload) {
+	setUploadHeaders(w, name, upload)
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func setUploadHeaders(w http.ResponseWriter, name string, upload *uploads.Upload) {
+	w.Header().Set("Location", PathPrefix+name+"/blobs/uploads/"+upload.ID)
+	w.Header().Set("Docker-Upload-UUID", upload.ID)
+	w.Header().Set("Range", fmt.Sprintf("0-%d", max(upload.Size-1, 0)))
+}
+
+// upload returns the upload id of the repository name, answering when it
+// is unknown
+func (g *Registry) upload(w http.ResponseWriter, name, id string) (*uploads.Upload, bool) {
+	upload, err := g.uploads.Get(id)
+	if err != nil || !upload.Streamed || upload.Metadata["repository"] != name {
+		writeError(w, http.StatusNotFound, codeBlobUploadUnknown, "blob upload unknown to registry")
+		return nil, false
+	}
+	return upload, true
+}
+
+// uploadStatus reports how much of an upload arrived
+func (g *Registry) uploadStatus(w http.ResponseWriter, r *http.Request, name, id string) {
+	upload, ok := g.upload(w, name, id)
+	if !ok {
+		return
+	}
+	setUploadHeaders(w, name, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// appendChunk appends the body of a request to an upload, answering and
+// returning false when it cannot
+func (g *Registry) appendChunk(w http.ResponseWriter, r *http.Request, name string, upload *uploads.Upload) (*uploads.Upload, bool) {
+	offset := upload.Size
+	if contentRange := r.Header.Get("Content-Range"); contentRange != "" {
+		start, _, _ := strings.Cut(strings.TrimPrefix(contentRange, "bytes="), "-")
+		if n, err := strconv.ParseInt(start, 10, 64); err != nil || n != upload.Size {
+			setUploadHeaders(w, name, upload)
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, codeBlobUploadInvalid, fmt.Sprintf("the upload has %d bytes", upload.Size))
+			return nil, false
+		}
+	}
+
+	size, err := g.uploads.Append(upload.ID, offset, &limitReader{r: r.Body, n: g.config.MaxBlobSize - offset})
+	switch {
+	case errors.Is(err, errTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, codeSizeInvalid, fmt.Sprintf("blobs are limited to %d bytes", g.config.MaxBlobSize))
+		return nil, false
+	case errors.Is(err, uploads.ErrOffset):
+		setUploadHeaders(w, name, upload)
+		writeError(w, http.StatusRequestedRangeNotSatisfiable, codeBlobUploadInvalid, err.Error())
+		return nil, false
+	case errors.Is(err, uploads.ErrNotFound):
+		writeError(w, http.StatusNotFound, codeBlobUploadUnknown, "blob upload unknown to registry")
+		return nil, false
+	case err != nil:
+		writeError(w, http.StatusBadRequest, codeBlobUploadInvalid, err.Error())
+		return nil, false
+	}
+	upload.Size = size
+	return upload, true
+}
+
+// patchUpload appends a chunk to an upload
+func (g *Registry) patchUpload(w http.ResponseWriter, r *http.Request, name, id string) {
+	upload, ok := g.upload(w, name, id)
+	if !ok {
+		return
+	}
+	if upload, ok = g.appendChunk(w, r, name, upload); ok {
+		uploadAccepted(w, name, upload)
+	}
+}
+
+// finishUpload appends the last chunk, if any, and stores the blob once
+// it has the digest the client names
+func (g *Registry) finishUpload(w http.ResponseWriter, r *http.Request, name, id string) {
+	d, err := parseDigest(r.URL.Query().Get("digest"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeDigestInvalid, err.Error())
+		return
+	}
+	upload, ok := g.upload(w, name, id)
+	if !ok {
+		return
+	}
+	if upload, ok = g.appendChunk(w, r, name, upload); !ok {
+		return
+	}
+
+	content, err := g.uploads.Open(upload.ID)
+	if err != nil {
+		g.internalError(w, r, err)
+		return
+	}
+	data, err := io.ReadAll(content)
+	content.Close()
+	if err != nil {
+		g.internalError(w, r, err)
+		return
+	}
+	if g.storeBlob(w, r, name, d, data) {
+		// The blob is stored; a leftover upload only wastes space until it
+		// expires
+		_ = g.uploads.Delete(upload.ID)
+	}
+}
+
+// storeBlob stores data as the blob with digest d after checking it
+func (g *Registry) storeBlob(w http.ResponseWriter, r *http.Request, name string, d digest, data []byte) bool {
+	if got := digestOf(d.algorithm, data); got != d {
+		writeError(w, http.StatusBadRequest, codeDigestInvalid, fmt.Sprintf("the content has digest %s", got))
+		return false
+	}
+	if err := g.putBlob(r, d, data); err != nil {
+		g.internalError(w, r, err)
+		return false
+	}
+	blobCreated(w, name, d)
+	return true
+}
+
+// cancelUpload drops an upload
+func (g *Registry) cancelUpload(w http.ResponseWriter, r *http.Request, name, id string) {
+	if _, ok := g.upload(w, name, id); !ok {
+		return
+	}
+	if err := g.uploads.Delete(id); err != nil && !errors.Is(err, uploads.ErrNotFound) {
+		g.internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
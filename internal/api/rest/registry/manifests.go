@@ -0,0 +1,348 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Media types of manifests
+const (
+	ociManifest    = "application/vnd.oci.image.manifest.v1+json"
+	ociIndex       = "application/vnd.oci.image.index.v1+json"
+	dockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// descriptor points to a blob or manifest
+type descriptor struct {
+	MediaType string   `json:"mediaType"`
+	Digest    string   `json:"digest"`
+	Size      int64    `json:"size"`
+	URLs      []string `json:"urls,omitempty"`
+}
+
+// manifest holds the fields of image manifests and indexes the registry
+// checks
+type manifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    *descriptor  `json:"config"`
+	Layers    []descriptor `json:"layers"`
+	Manifests []descriptor `json:"manifests"`
+}
+
+// mediaType returns the media type of a manifest, which OCI manifests may
+// leave out
+func (m *manifest) mediaType() string {
+	switch {
+	case m.MediaType != "":
+		return m.MediaType
+	case m.Manifests != nil:
+		return ociIndex
+	default:
+		return ociManifest
+	}
+}
+
+// resolve returns the digest of a tag or digest of the repository name
+func (g *Registry) resolve(r *http.Request, name, ref string) (digest, error) {
+	if d, err := parseDigest(ref); err == nil {
+		return d, nil
+	}
+	if !tagRE.MatchString(ref) {
+		return digest{}, fmt.Errorf("invalid reference %q", ref)
+	}
+	data, err := g.store.Read(r.Context(), g.repoKey(name, "tags", ref))
+	if err != nil {
+		return digest{}, err
+	}
+	return parseDigest(strings.TrimSpace(string(data)))
+}
+
+// manifestKey returns the key of the manifest with digest d
+func (g *Registry) manifestKey(name string, d digest) string {
+	return g.repoKey(name, "manifests", d.algorithm, d.hex)
+}
+
+// getManifest sends a manifest by tag or digest
+func (g *Registry) getManifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	d, err := g.resolve(r, name, ref)
+	var data []byte
+	if err == nil {
+		data, err = g.store.Read(r.Context(), g.manifestKey(name, d))
+	}
+	if notFound(err) {
+		writeError(w, http.StatusNotFound, codeManifestUnknown, "manifest unknown to registry")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeManifestInvalid, err.Error())
+		return
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		g.internalError(w, r, fmt.Errorf("stored manifest %s: %w", d, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", m.mediaType())
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Docker-Content-Digest", d.String())
+	w.Header().Set("ETag", `"`+d.String()+`"`)
+	if r.Method != http.MethodHead {
+		w.Write(data)
+	}
+}
+
+// putManifest stores a manifest once the blobs and manifests it points to
+// are in the registry, and tags it unless ref is its digest
+func (g *Registry) putManifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	data, err := io.ReadAll(&limitReader{r: r.Body, n: g.config.MaxManifestSize})
+	if errors.Is(err, errTooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, codeSizeInvalid, fmt.Sprintf("manifests are limited to %d bytes", g.config.MaxManifestSize))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeManifestInvalid, err.Error())
+		return
+	}
+
+	d := digestOf("sha256", data)
+	tag := ""
+	if want, err := parseDigest(ref); err == nil {
+		if d = digestOf(want.algorithm, data); d != want {
+			writeError(w, http.StatusBadRequest, codeDigestInvalid, fmt.Sprintf("the manifest has digest %s", d))
+			return
+		}
+	} else if tagRE.MatchString(ref) {
+		tag = ref
+	} else {
+		writeError(w, http.StatusBadRequest, codeManifestInvalid, fmt.Sprintf("invalid reference %q", ref))
+		return
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		writeError(w, http.StatusBadRequest, codeManifestInvalid, err.Error())
+		return
+	}
+	if contentType := r.Header.Get("Content-Type"); m.MediaType != "" && contentType != "" && contentType != m.MediaType {
+		writeError(w, http.StatusBadRequest, codeManifestInvalid, fmt.Sprintf("the manifest is %s, not %s", m.MediaType, contentType))
+		return
+	}
+	if status, code, err := g.checkReferences(r, name, &m); err != nil {
+		writeError(w, status, code, err.Error())
+		return
+	}
+
+	if err := g.store.Write(r.Context(), g.manifestKey(name, d), data); err != nil {
+		g.internalError(w, r, err)
+		return
+	}
+	if tag != "" {
+		if err := g.store.Write(r.Context(), g.repoKey(name, "tags", tag), []byte(d.String())); err != nil {
+			g.internalError(w, r, err)
+			return
+		}
+	}
+	w.Header().Set("Location", PathPrefix+name+"/manifests/"+d.String())
+	w.Header().Set("Docker-Content-Digest", d.String())
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// checkReferences checks that the config and layers of an image manifest
+// are stored blobs, and that the manifests of an index are in the
+// repository, returning the error to answer with otherwise
+func (g *Registry) checkReferences(r *http.Request, name string, m *manifest) (int, string, error) {
+	switch m.mediaType() {
+	case ociManifest, dockerManifest:
+		if m.Config == nil {
+			return http.StatusBadRequest, codeManifestInvalid, errors.New("the manifest has no config")
+		}
+		for _, desc := range append([]descriptor{*m.Config}, m.Layers...) {
+			// Foreign layers are fetched from their URLs
+			if len(desc.URLs) > 0 {
+				continue
+			}
+			d, err := parseDigest(desc.Digest)
+			if err != nil {
+				return http.StatusBadRequest, codeManifestInvalid, err
+			}
+			if _, err := g.stat(r.Context(), g.blobKey(d)); err != nil {
+				return http.StatusNotFound, codeManifestBlobUnknown, fmt.Errorf("blob %s is unknown to the registry", d)
+			}
+		}
+	case ociIndex, dockerList:
+		for _, desc := range m.Manifests {
+			d, err := parseDigest(desc.Digest)
+			if err != nil {
+				return http.StatusBadRequest, codeManifestInvalid, err
+			}
+			if _, err := g.stat(r.Context(), g.manifestKey(name, d)); err != nil {
+				return http.StatusNotFound, codeManifestBlobUnknown, fmt.Errorf("manifest %s is unknown to the repository", d)
+			}
+		}
+	}
+	return 0, "", nil
+}
+
+// deleteManifest deletes a tag, or a manifest and the tags pointing to it
+func (g *Registry) deleteManifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	d, err := parseDigest(ref)
+	if err != nil {
+		if !tagRE.MatchString(ref) {
+			writeError(w, http.StatusBadRequest, codeManifestInvalid, fmt.Sprintf("invalid reference %q", ref))
+			return
+		}
+		if g.deleteFile(w, r, g.repoKey(name, "tags", ref), "tag unknown to registry") {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		return
+	}
+
+	if !g.deleteFile(w, r, g.manifestKey(name, d), "manifest unknown to registry") {
+		return
+	}
+	tags, err := g.tags(r, name)
+	if err != nil {
+		g.internalError(w, r, err)
+		return
+	}
+	for _, tag := range tags {
+		if target, err := g.resolve(r, name, tag); err == nil && target == d {
+			if err := g.store.Delete(r.Context(), g.repoKey(name, "tags", tag)); err != nil {
+				g.internalError(w, r, err)
+				return
+			}
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// deleteFile deletes the tag or manifest at key, answering unless it was
+// deleted
+func (g *Registry) deleteFile(w http.ResponseWriter, r *http.Request, key, unknown string) bool {
+	_, err := g.stat(r.Context(), key)
+	if err == nil {
+		err = g.store.Delete(r.Context(), key)
+	}
+	switch {
+	case notFound(err):
+		writeError(w, http.StatusNotFound, codeManifestUnknown, unknown)
+		return false
+	case err != nil:
+		g.internalError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// tags returns the tags of a repository, sorted
+func (g *Registry) tags(r *http.Request, name string) ([]string, error) {
+	prefix := g.repoKey(name, "tags", "")
+	objects, err := g.store.List(r.Context(), prefix)
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for _, o := range objects {
+		if tag := strings.TrimPrefix(o.Key, prefix); tagRE.MatchString(tag) {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// listTags lists the tags of a repository
+func (g *Registry) listTags(w http.ResponseWriter, r *http.Request, name string) {
+	objects, err := g.store.List(r.Context(), g.repoKey(name))
+	if err != nil {
+		g.internalError(w, r, err)
+		return
+	}
+	if len(objects) == 0 {
+		writeError(w, http.StatusNotFound, codeNameUnknown, "repository name not known to registry")
+		return
+	}
+	tags, err := g.tags(r, name)
+	if err != nil {
+		g.internalError(w, r, err)
+		return
+	}
+	page, ok := paginate(w, r, tags, PathPrefix+name+"/tags/list")
+	if !ok {
+		return
+	}
+	writeJSON(w, map[string]any{"name": name, "tags": page})
+}
+
+// catalog lists the repositories of the registry
+func (g *Registry) catalog(w http.ResponseWriter, r *http.Request) {
+	prefix := g.root + "repositories/"
+	objects, err := g.store.List(r.Context(), prefix)
+	if err != nil {
+		g.internalError(w, r, err)
+		return
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, o := range objects {
+		rest := strings.TrimPrefix(o.Key, prefix)
+		for _, marker := range []string{"/manifests/", "/tags/"} {
+			if name, _, ok := strings.Cut(rest, marker); ok && !seen[name] && nameRE.MatchString(name) {
+				seen[name] = true
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	page, ok := paginate(w, r, names, PathPrefix+"_catalog")
+	if !ok {
+		return
+	}
+	writeJSON(w, map[string]any{"repositories": page})
+}
+
+// paginate returns the page of the sorted items the n and last query
+// parameters ask for, linking to the next page
+func paginate(w http.ResponseWriter, r *http.Request, items []string, path string) ([]string, bool) {
+	q := r.URL.Query()
+	if last := q.Get("last"); last != "" {
+		items = items[sort.SearchStrings(items, last):]
+		if len(items) > 0 && items[0] == last {
+			items = items[1:]
+		}
+	}
+	if s := q.Get("n"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, codeUnsupported, "invalid n")
+			return nil, false
+		}
+		if n < len(items) {
+			items = items[:n]
+			if n > 0 {
+				next := url.Values{"n": {s}, "last": {items[n-1]}}
+				w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, path, next.Encode()))
+			}
+		}
+	}
+	if items == nil {
+		items = []string{}
+	}
+	return items, true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
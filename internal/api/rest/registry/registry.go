@@ -0,0 +1,276 @@
+// Package registry serves the OCI Distribution API, the /v2/ API of
+// container registries, from the files of the store, so docker, podman and
+// other OCI clients push and pull images to PeerVault, which replicates
+// them like any other file.
+//
+// Blobs are content addressed: each is stored once, under
+// <root>/blobs/<algorithm>/<hex>, whichever repositories use it, and a
+// blob pushed to one repository is mounted in another without uploading it
+// again. Manifests are kept per repository under
+// <root>/repositories/<name>/manifests/<algorithm>/<hex>, and tags under
+// <root>/repositories/<name>/tags/<tag>, holding the digest of their
+// manifest. Blob uploads, whether sent in one request or in chunks, are
+// staged as streamed uploads of the resumable upload subsystem and stored
+// once their digest is checked. Blobs are never deleted, since other
+// repositories may use them; the referrers API is not served.
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"github.com/Skpow1234/Peervault/internal/uploads"
+)
+
+// PathPrefix is where the registry is served
+const PathPrefix = "/v2/"
+
+// Config holds the configuration of the registry
+type Config struct {
+	// Root is the folder of the store holding blobs and repositories
+	Root string
+	// MaxBlobSize is the largest blob in bytes clients may push
+	MaxBlobSize int64
+	// MaxManifestSize is the largest manifest in bytes
+	MaxManifestSize int64
+}
+
+// DefaultConfig returns the default registry configuration
+func DefaultConfig() *Config {
+	return &Config{
+		Root:            "registry",
+		MaxBlobSize:     1 << 30,
+		MaxManifestSize: 4 << 20,
+	}
+}
+
+// Store holds the registry, see directory.Store
+type Store interface {
+	directory.Store
+	// Read returns the content of a file
+	Read(ctx context.Context, key string) ([]byte, error)
+	// Write stores the content of a file, replacing it
+	Write(ctx context.Context, key string, data []byte) error
+}
+
+// Registry answers OCI clients
+type Registry struct {
+	store   Store
+	uploads *uploads.Manager
+	config  *Config
+	logger  *slog.Logger
+	root    string
+}
+
+// New creates a registry kept in store, staging blob uploads in uploads
+func New(store Store, uploads *uploads.Manager, config *Config, logger *slog.Logger) (*Registry, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	root, err := directory.CleanDir(config.Root)
+	if err != nil || root == "" {
+		return nil, fmt.Errorf("registry: invalid root %q", config.Root)
+	}
+	return &Registry{store: store, uploads: uploads, config: config, logger: logger, root: root}, nil
+}
+
+var (
+	// nameRE matches repository names, as the distribution spec defines them
+	nameRE = regexp.MustCompile(`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*(/[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*)*$`)
+	// tagRE matches tags
+	tagRE = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+)
+
+// Error codes of the distribution spec
+const (
+	codeBlobUnknown         = "BLOB_UNKNOWN"
+	codeBlobUploadInvalid   = "BLOB_UPLOAD_INVALID"
+	codeBlobUploadUnknown   = "BLOB_UPLOAD_UNKNOWN"
+	codeDigestInvalid       = "DIGEST_INVALID"
+	codeManifestBlobUnknown = "MANIFEST_BLOB_UNKNOWN"
+	codeManifestInvalid     = "MANIFEST_INVALID"
+	codeManifestUnknown     = "MANIFEST_UNKNOWN"
+	codeNameInvalid         = "NAME_INVALID"
+	codeNameUnknown         = "NAME_UNKNOWN"
+	codeSizeInvalid         = "SIZE_INVALID"
+	codeUnauthorized        = "UNAUTHORIZED"
+	codeUnsupported         = "UNSUPPORTED"
+)
+
+// writeError answers with an error of the distribution spec
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
+
+// Challenge answers a request without valid credentials, asking clients
+// such as docker login for them
+func Challenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="PeerVault"`)
+	writeError(w, http.StatusUnauthorized, codeUnauthorized, "authentication required")
+}
+
+// Token returns the token of a request, sent as a bearer token or as the
+// password of basic authentication, which is what docker login sends
+func Token(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+		return token
+	}
+	if encoded, ok := strings.CutPrefix(auth, "Basic "); ok {
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return ""
+		}
+		_, password, _ := strings.Cut(string(decoded), ":")
+		return password
+	}
+	return ""
+}
+
+// ServeHTTP routes the requests of the /v2/ API
+func (g *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	p := strings.TrimPrefix(r.URL.Path, PathPrefix)
+	switch {
+	case p == "":
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, http.StatusMethodNotAllowed, codeUnsupported, "method not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+		return
+	case p == "_catalog":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, codeUnsupported, "method not allowed")
+			return
+		}
+		g.catalog(w, r)
+		return
+	}
+
+	segs := strings.Split(p, "/")
+	n := len(segs)
+	var name string
+	var handler func(http.ResponseWriter, *http.Request, string, string)
+	var arg string
+	switch {
+	case n >= 3 && segs[n-2] == "tags" && segs[n-1] == "list":
+		name, handler = strings.Join(segs[:n-2], "/"), g.routeTags
+	case n >= 3 && segs[n-2] == "manifests":
+		name, handler, arg = strings.Join(segs[:n-2], "/"), g.routeManifest, segs[n-1]
+	case n >= 4 && segs[n-3] == "blobs" && segs[n-2] == "uploads":
+		name, handler, arg = strings.Join(segs[:n-3], "/"), g.routeUpload, segs[n-1]
+	case n >= 3 && segs[n-2] == "blobs" && segs[n-1] == "uploads":
+		name, handler = strings.Join(segs[:n-2], "/"), g.routeUpload
+	case n >= 3 && segs[n-2] == "blobs":
+		name, handler, arg = strings.Join(segs[:n-2], "/"), g.routeBlob, segs[n-1]
+	default:
+		writeError(w, http.StatusNotFound, codeUnsupported, "unknown endpoint")
+		return
+	}
+	if !nameRE.MatchString(name) || len(name) > 255 {
+		writeError(w, http.StatusBadRequest, codeNameInvalid, fmt.Sprintf("invalid repository name %q", name))
+		return
+	}
+	handler(w, r, name, arg)
+}
+
+func (g *Registry) routeTags(w http.ResponseWriter, r *http.Request, name, _ string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeUnsupported, "method not allowed")
+		return
+	}
+	g.listTags(w, r, name)
+}
+
+func (g *Registry) routeManifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		g.getManifest(w, r, name, ref)
+	case http.MethodPut:
+		g.putManifest(w, r, name, ref)
+	case http.MethodDelete:
+		g.deleteManifest(w, r, name, ref)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeUnsupported, "method not allowed")
+	}
+}
+
+func (g *Registry) routeBlob(w http.ResponseWriter, r *http.Request, name, digest string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		g.getBlob(w, r, digest)
+	case http.MethodDelete:
+		writeError(w, http.StatusMethodNotAllowed, codeUnsupported, "blobs are shared by repositories and are not deleted")
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeUnsupported, "method not allowed")
+	}
+}
+
+func (g *Registry) routeUpload(w http.ResponseWriter, r *http.Request, name, id string) {
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		g.startUpload(w, r, name)
+	case id != "" && r.Method == http.MethodGet:
+		g.uploadStatus(w, r, name, id)
+	case id != "" && r.Method == http.MethodPatch:
+		g.patchUpload(w, r, name, id)
+	case id != "" && r.Method == http.MethodPut:
+		g.finishUpload(w, r, name, id)
+	case id != "" && r.Method == http.MethodDelete:
+		g.cancelUpload(w, r, name, id)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeUnsupported, "method not allowed")
+	}
+}
+
+// repoKey returns the key of path in the repository name
+func (g *Registry) repoKey(name string, path ...string) string {
+	return g.root + "repositories/" + name + "/" + strings.Join(path, "/")
+}
+
+// blobKey returns the key of the blob with digest d
+func (g *Registry) blobKey(d digest) string {
+	return g.root + "blobs/" + d.algorithm + "/" + d.hex
+}
+
+// stat returns the size of the file at key
+func (g *Registry) stat(ctx context.Context, key string) (int64, error) {
+	objects, err := g.store.List(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	for _, o := range objects {
+		if o.Key == key {
+			return o.Size, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", directory.ErrNotFound, key)
+}
+
+// internalError logs err and answers with a server error
+func (g *Registry) internalError(w http.ResponseWriter, r *http.Request, err error) {
+	g.logger.ErrorContext(r.Context(), "Registry request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	writeError(w, http.StatusInternalServerError, "UNKNOWN", "internal error")
+}
+
+// notFound reports whether err means a file does not exist
+func notFound(err error) bool {
+	return errors.Is(err, directory.ErrNotFound)
+}
@@ -0,0 +1,311 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"github.com/Skpow1234/Peervault/internal/uploads"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore is a Store keeping files in memory
+type memStore struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (s *memStore) List(ctx context.Context, prefix string) ([]directory.Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var objects []directory.Object
+	for k, data := range s.files {
+		if strings.HasPrefix(k, prefix) {
+			objects = append(objects, directory.Object{Key: k, Size: int64(len(data))})
+		}
+	}
+	return objects, nil
+}
+
+func (s *memStore) Copy(ctx context.Context, from, to string, overwrite bool) error {
+	return directory.ErrNotFound
+}
+
+func (s *memStore) Rename(ctx context.Context, from, to string, overwrite bool) error {
+	return directory.ErrNotFound
+}
+
+func (s *memStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Like the file service, missing files are not reported as
+	// directory.ErrNotFound
+	if _, ok := s.files[key]; !ok {
+		return fmt.Errorf("file not found: %s", key)
+	}
+	delete(s.files, key)
+	return nil
+}
+
+func (s *memStore) Mkdir(ctx context.Context, key string) error {
+	return nil
+}
+
+func (s *memStore) Read(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", directory.ErrNotFound, key)
+	}
+	return data, nil
+}
+
+func (s *memStore) Write(ctx context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[key] = data
+	return nil
+}
+
+func newTestRegistry(t *testing.T) (*httptest.Server, *memStore) {
+	t.Helper()
+	store := &memStore{files: make(map[string][]byte)}
+	manager, err := uploads.NewManager(uploads.ManagerOpts{Dir: t.TempDir()})
+	require.NoError(t, err)
+	config := DefaultConfig()
+	config.MaxBlobSize = 1 << 10
+	g, err := New(store, manager, config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	srv := httptest.NewServer(g)
+	t.Cleanup(srv.Close)
+	return srv, store
+}
+
+func sha(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// do sends a request and returns the response with its body read
+func do(t *testing.T, method, url string, body []byte, header ...string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	require.NoError(t, err)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(data)
+}
+
+// imageManifest returns an image manifest of the config and layers
+func imageManifest(config []byte, layers ...[]byte) []byte {
+	m := map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     ociManifest,
+		"config":        descriptor{MediaType: "application/vnd.oci.image.config.v1+json", Digest: sha(config), Size: int64(len(config))},
+	}
+	var descs []descriptor
+	for _, l := range layers {
+		descs = append(descs, descriptor{MediaType: "application/vnd.oci.image.layer.v1.tar", Digest: sha(l), Size: int64(len(l))})
+	}
+	m["layers"] = descs
+	data, _ := json.Marshal(m)
+	return data
+}
+
+func TestBlobUploads(t *testing.T) {
+	srv, _ := newTestRegistry(t)
+	base := srv.URL + PathPrefix
+
+	resp, body := do(t, "GET", base, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "{}", body)
+	assert.Equal(t, "registry/2.0", resp.Header.Get("Docker-Distribution-API-Version"))
+
+	// Monolithic upload
+	config := []byte(`{"architecture":"amd64"}`)
+	resp, _ = do(t, "POST", base+"library/app/blobs/uploads/?digest="+sha(config), config)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, PathPrefix+"library/app/blobs/"+sha(config), resp.Header.Get("Location"))
+
+	resp, _ = do(t, "POST", base+"library/app/blobs/uploads/?digest="+sha([]byte("other")), config)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Chunked upload
+	layer := []byte("layer content sent in chunks")
+	resp, _ = do(t, "POST", base+"library/app/blobs/uploads/", nil)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	location := resp.Header.Get("Location")
+	require.True(t, strings.HasPrefix(location, PathPrefix+"library/app/blobs/uploads/"))
+
+	resp, _ = do(t, "PATCH", srv.URL+location, layer[:10], "Content-Range", "0-9")
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "0-9", resp.Header.Get("Range"))
+
+	// A chunk that does not follow the last one is refused
+	resp, _ = do(t, "PATCH", srv.URL+location, layer[12:], "Content-Range", "12-27")
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+	assert.Equal(t, "0-9", resp.Header.Get("Range"))
+
+	resp, _ = do(t, "GET", srv.URL+location, nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "0-9", resp.Header.Get("Range"))
+
+	// The upload belongs to its repository
+	resp, _ = do(t, "GET", srv.URL+strings.Replace(location, "library/app", "library/other", 1), nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = do(t, "PUT", srv.URL+location+"?digest="+sha(layer), layer[10:])
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, sha(layer), resp.Header.Get("Docker-Content-Digest"))
+	resp, _ = do(t, "GET", srv.URL+location, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, body = do(t, "GET", base+"library/app/blobs/"+sha(layer), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, string(layer), body)
+	resp, _ = do(t, "GET", base+"library/app/blobs/"+sha(layer), nil, "Range", "bytes=0-4")
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	resp, body = do(t, "HEAD", base+"library/app/blobs/"+sha(layer), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, fmt.Sprint(len(layer)), resp.Header.Get("Content-Length"))
+	assert.Empty(t, body)
+
+	// Stored blobs are mounted in other repositories without uploading
+	resp, _ = do(t, "POST", base+"team/tool/blobs/uploads/?mount="+sha(layer)+"&from=library/app", nil)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, _ = do(t, "POST", base+"team/tool/blobs/uploads/?mount="+sha([]byte("missing")), nil)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	// Uploads that disagree with their digest, or are too large, are refused
+	resp, _ = do(t, "POST", base+"library/app/blobs/uploads/", nil)
+	location = resp.Header.Get("Location")
+	resp, body = do(t, "PUT", srv.URL+location+"?digest="+sha([]byte("x")), []byte("y"))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, body, codeDigestInvalid)
+	resp, _ = do(t, "PATCH", srv.URL+location, bytes.Repeat([]byte("x"), 2<<10))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	resp, _ = do(t, "DELETE", srv.URL+location, nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, _ = do(t, "GET", base+"library/app/blobs/"+sha([]byte("missing")), nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = do(t, "DELETE", base+"library/app/blobs/"+sha(layer), nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	resp, _ = do(t, "GET", base+"Library/App/blobs/"+sha(layer), nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestManifests(t *testing.T) {
+	srv, store := newTestRegistry(t)
+	base := srv.URL + PathPrefix
+	config, layer := []byte(`{}`), []byte("layer")
+	manifest := imageManifest(config, layer)
+
+	// Manifests need their blobs
+	resp, body := do(t, "PUT", base+"app/manifests/v1", manifest, "Content-Type", ociManifest)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Contains(t, body, codeManifestBlobUnknown)
+
+	for _, blob := range [][]byte{config, layer} {
+		resp, _ = do(t, "POST", base+"app/blobs/uploads/?digest="+sha(blob), blob)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+	resp, _ = do(t, "PUT", base+"app/manifests/v1", manifest, "Content-Type", dockerManifest)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = do(t, "PUT", base+"app/manifests/v1", manifest, "Content-Type", ociManifest)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, sha(manifest), resp.Header.Get("Docker-Content-Digest"))
+	resp, _ = do(t, "PUT", base+"app/manifests/"+sha([]byte("other")), manifest, "Content-Type", ociManifest)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	for _, ref := range []string{"v1", sha(manifest)} {
+		resp, body = do(t, "GET", base+"app/manifests/"+ref, nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode, ref)
+		assert.Equal(t, string(manifest), body)
+		assert.Equal(t, ociManifest, resp.Header.Get("Content-Type"))
+		assert.Equal(t, sha(manifest), resp.Header.Get("Docker-Content-Digest"))
+	}
+	resp, _ = do(t, "HEAD", base+"app/manifests/v2", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// An index of the manifest
+	index, _ := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     ociIndex,
+		"manifests":     []descriptor{{MediaType: ociManifest, Digest: sha(manifest), Size: int64(len(manifest))}},
+	})
+	resp, _ = do(t, "PUT", base+"app/manifests/latest", index, "Content-Type", ociIndex)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, _ = do(t, "PUT", base+"other/manifests/latest", index, "Content-Type", ociIndex)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	for _, tag := range []string{"v2", "v3"} {
+		resp, _ = do(t, "PUT", base+"app/manifests/"+tag, manifest, "Content-Type", ociManifest)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+	resp, body = do(t, "GET", base+"app/tags/list", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"name":"app","tags":["latest","v1","v2","v3"]}`, body)
+	resp, body = do(t, "GET", base+"app/tags/list?n=2&last=latest", nil)
+	assert.JSONEq(t, `{"name":"app","tags":["v1","v2"]}`, body)
+	assert.Equal(t, `</v2/app/tags/list?last=v2&n=2>; rel="next"`, resp.Header.Get("Link"))
+	resp, _ = do(t, "GET", base+"nope/tags/list", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = do(t, "POST", base+"team/app/blobs/uploads/?digest="+sha(config), config)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, _ = do(t, "PUT", base+"team/app/manifests/"+sha(config), config)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, "a config is not a manifest")
+	_, body = do(t, "GET", base+"_catalog", nil)
+	assert.JSONEq(t, `{"repositories":["app"]}`, body)
+
+	// Deleting a tag keeps the manifest; deleting the manifest drops its
+	// tags but not its blobs
+	resp, _ = do(t, "DELETE", base+"app/manifests/v3", nil)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	resp, _ = do(t, "DELETE", base+"app/manifests/v3", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = do(t, "DELETE", base+"app/manifests/"+sha(manifest), nil)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	resp, _ = do(t, "DELETE", base+"app/manifests/"+sha(manifest), nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	_, body = do(t, "GET", base+"app/tags/list", nil)
+	assert.JSONEq(t, `{"name":"app","tags":["latest"]}`, body)
+	resp, _ = do(t, "GET", base+"app/manifests/v1", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	_, ok := store.files["registry/blobs/sha256/"+strings.TrimPrefix(sha(layer), "sha256:")]
+	assert.True(t, ok)
+}
+
+func TestToken(t *testing.T) {
+	r := httptest.NewRequest("GET", PathPrefix, nil)
+	assert.Empty(t, Token(r))
+	r.SetBasicAuth("user", "secret")
+	assert.Equal(t, "secret", Token(r))
+	r.Header.Set("Authorization", "Bearer token")
+	assert.Equal(t, "token", Token(r))
+
+	w := httptest.NewRecorder()
+	Challenge(w)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="PeerVault"`, w.Header().Get("WWW-Authenticate"))
+}
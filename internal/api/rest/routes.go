@@ -0,0 +1,1593 @@
+package rest
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/Skpow1234/Peervault/internal/alerting"
+	"github.com/Skpow1234/Peervault/internal/analytics"
+	"github.com/Skpow1234/Peervault/internal/api/mqtt/topicacl"
+	"github.com/Skpow1234/Peervault/internal/api/rest/endpoints"
+	"github.com/Skpow1234/Peervault/internal/api/rest/gateway"
+	"github.com/Skpow1234/Peervault/internal/api/rest/openapi"
+	"github.com/Skpow1234/Peervault/internal/api/rest/registry"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/backup"
+	"github.com/Skpow1234/Peervault/internal/consensus"
+	"github.com/Skpow1234/Peervault/internal/crdt"
+	"github.com/Skpow1234/Peervault/internal/devices"
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"github.com/Skpow1234/Peervault/internal/export"
+	"github.com/Skpow1234/Peervault/internal/firmware"
+	"github.com/Skpow1234/Peervault/internal/georeplication"
+	"github.com/Skpow1234/Peervault/internal/grafana"
+	"github.com/Skpow1234/Peervault/internal/jsondoc"
+	"github.com/Skpow1234/Peervault/internal/lifecycle"
+	"github.com/Skpow1234/Peervault/internal/lwm2m"
+	"github.com/Skpow1234/Peervault/internal/media"
+	"github.com/Skpow1234/Peervault/internal/peer"
+	"github.com/Skpow1234/Peervault/internal/queue"
+	"github.com/Skpow1234/Peervault/internal/scheduler"
+	"github.com/Skpow1234/Peervault/internal/sharing"
+	"github.com/Skpow1234/Peervault/internal/snapshot"
+	"github.com/Skpow1234/Peervault/internal/storage"
+	"github.com/Skpow1234/Peervault/internal/streamlog"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/acl"
+	"github.com/Skpow1234/Peervault/pkg/merkle"
+)
+
+//go:generate go run ../../../cmd/peervault-api -dump-openapi -openapi-out ../../../docs/api/peervault-rest-api.yaml
+
+// route is an endpoint together with its OpenAPI description. The server
+// registers exactly the routes it documents.
+type route struct {
+	openapi.Operation
+	handler http.Handler
+	// disabled routes belong to optional features that are not configured;
+	// they are documented but not served
+	disabled bool
+}
+
+// routes lists every endpoint of the API. It only takes method values of
+// the endpoint sets, so it also works on a Server whose optional endpoint
+// sets are nil.
+func (s *Server) routes() []route {
+	f := func(h http.HandlerFunc) http.Handler { return h }
+	notFound := openapi.Error(http.StatusNotFound, "File not found")
+	badRequest := openapi.Error(http.StatusBadRequest, "Invalid request")
+	leaseNotFound := openapi.Error(http.StatusNotFound, "Lease not found or expired")
+	leaseUnavailable := openapi.Error(http.StatusServiceUnavailable, "The Raft group has no leader or cannot be reached")
+	fileLocked := openapi.Error(http.StatusLocked, "A file is under a retention lock or legal hold")
+	lwm2mNotFound := openapi.Error(http.StatusNotFound, "Device not registered")
+	lwm2mErrors := []openapi.Response{
+		badRequest,
+		openapi.Error(http.StatusForbidden, "The device refused"),
+		openapi.Error(http.StatusNotFound, "Device not registered or resource not found"),
+		openapi.Error(http.StatusMethodNotAllowed, "The resource does not support the operation"),
+		openapi.Error(http.StatusBadGateway, "The device answered with an error"),
+		openapi.Error(http.StatusGatewayTimeout, "The device did not answer"),
+	}
+	imageNotFound := openapi.Error(http.StatusNotFound, "Image not found")
+	rolloutNotFound := openapi.Error(http.StatusNotFound, "Rollout not found")
+	firmwareDenied := openapi.Error(http.StatusForbidden, "The device is not registered with the device CA or is revoked")
+	dirNotFound := openapi.Error(http.StatusNotFound, "No key is below the path")
+	recursive := openapi.Query("recursive", "boolean", "Include everything below the folder")
+	snapshotNotFound := openapi.Error(http.StatusNotFound, "Snapshot not found")
+	versionNotFound := openapi.Error(http.StatusNotFound, "File or version not found on this node")
+	noConflict := openapi.Error(http.StatusConflict, "The file has no conflicting versions, or is locked")
+	documentNotFound := openapi.Error(http.StatusNotFound, "Document not found")
+	deviceNotFound := openapi.Error(http.StatusNotFound, "Device not found")
+	enrollDenied := openapi.Error(http.StatusUnauthorized, "Unknown or revoked device, or the secret was not proven")
+	estCerts := openapi.Response{Status: http.StatusOK, Description: "A base64 PKCS#7 certs-only message", ContentType: "application/pkcs7-mime",
+		Schema: &openapi.Schema{Type: "string", Format: "byte"}}
+	csrBody := &openapi.Body{ContentType: "application/pkcs10", Schema: &openapi.Schema{Type: "string", Format: "byte", Description: "A base64 DER PKCS#10 request"}}
+	reportNotFound := openapi.Error(http.StatusNotFound, "Report not found")
+	jobNotFound := openapi.Error(http.StatusNotFound, "Job not found")
+	alertNotFound := openapi.Error(http.StatusNotFound, "Rule, channel or silence not found")
+	policyDenied := openapi.Error(http.StatusForbidden, "A policy rule denied the operation")
+	storageFull := openapi.Error(http.StatusInsufficientStorage, "The node's storage is above its high watermark")
+	scanRefused := openapi.Error(http.StatusUnprocessableEntity, "The content scanner rejected or quarantined the file")
+	scanUnavailable := openapi.Error(http.StatusServiceUnavailable, "The content scanner could not check the file")
+	mediaUnsupported := openapi.Error(http.StatusUnsupportedMediaType, "No preview or stream can be made of the file")
+	streamNotFound := openapi.Error(http.StatusNotFound, "File, profile or segment not found")
+	anyJSON := &openapi.Schema{Description: "Any JSON value"}
+	jsonNotFound := openapi.Error(http.StatusNotFound, "Document not found")
+	notJSON := openapi.Error(http.StatusUnsupportedMediaType, "The file at the key is not a JSON document")
+	preconditionFailed := openapi.Error(http.StatusPreconditionFailed, "The document does not have the ETag of If-Match, or exists despite If-None-Match")
+	schemaMismatch := openapi.Error(http.StatusUnprocessableEntity, "The document does not match the JSON Schema of its key")
+	jsonConditions := []openapi.Param{
+		openapi.Header("If-Match", "ETags the document must have, or * for any existing document"),
+		openapi.Header("If-None-Match", "* to write only if no document exists"),
+	}
+	fileChanged := openapi.Error(http.StatusPreconditionFailed, "The file does not have the ETag of If-Match, or has one of If-None-Match")
+	readConditions := []openapi.Param{
+		openapi.Header("If-Match", "ETags the file must have"),
+		openapi.Header("If-None-Match", "ETags of a cached copy; answered 304 while the file has one of them"),
+	}
+	writeConditions := []openapi.Param{
+		openapi.Header("If-Match", "ETags the replaced file must have, or * for any existing file"),
+		openapi.Header("If-None-Match", "* to write only if no file exists, or ETags the replaced file must not have"),
+	}
+	logNotFound := openapi.Error(http.StatusNotFound, "Log not found")
+	accessParams := []openapi.Param{
+		openapi.Query("dimension", "string", "key, tenant or peer (default key)"),
+		openapi.Query("value", "string", "Only this key, tenant or peer"),
+		openapi.Query("from", "string", "RFC 3339 start of the range"),
+		openapi.Query("to", "string", "RFC 3339 end of the range"),
+	}
+	key := openapi.RequiredQuery("key", "string", "Key of the file")
+
+	return []route{
+		// Files
+		{handler: f(s.FileEndpoints.HandleListFiles), Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/files", ID: "listFiles", Tag: "Files", Summary: "List files",
+			Description: "Lists all files, or those carrying every given tag and `meta.<key>=<value>` metadata value.",
+			Params:      []openapi.Param{openapi.Query("tag", "string", "Comma-separated tags the files must carry; may be repeated")},
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The files", responses.FileListResponse{})},
+		}},
+		{handler: f(s.FileEndpoints.HandleGetFile), Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/files/get", ID: "getFile", Tag: "Files", Summary: "Get file metadata",
+			Description: "The ETag of a file is the quoted SHA-256 of its content.",
+			Params:      append([]openapi.Param{key}, readConditions...),
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The file", responses.FileResponse{}),
+				openapi.Empty(http.StatusNotModified, "The file matches If-None-Match"),
+				badRequest,
+				notFound,
+				fileChanged,
+			},
+		}},
+		{handler: f(s.FileEndpoints.HandleDownloadFile), Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/files/{key}/content", ID: "downloadFile", Tag: "Files", Summary: "Download file content",
+			Description: "Supports Range requests; the ETag of the file, the quoted SHA-256 of its content, is checked by If-Range, If-Match and If-None-Match.",
+			Params:      readConditions,
+			Responses: []openapi.Response{
+				openapi.Binary(http.StatusOK, "The file content"),
+				openapi.Binary(http.StatusPartialContent, "The requested range"),
+				openapi.Empty(http.StatusNotModified, "The file matches If-None-Match"),
+				notFound,
+				fileChanged,
+			},
+		}},
+		{handler: f(s.FileEndpoints.HandleGetFileManifest), Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/files/{key}/manifest", ID: "getFileManifest", Tag: "Files", Summary: "Get the Merkle tree of a file",
+			Description: "Lists the SHA-256 of each chunk of the content and the Merkle root they hash up to. At the default chunk size the root is the `merkle_root` of the file, so a client trusting it can verify the chunk hashes, then each chunk or byte range it downloads.",
+			Params:      []openapi.Param{openapi.Query("chunk_size", "integer", "Chunk size in bytes, 1 KiB to 64 MiB (default 1 MiB)")},
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The manifest", merkle.Manifest{}), badRequest, notFound},
+		}},
+		{handler: f(s.FileEndpoints.HandleGetUsage), Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/files/usage", ID: "getFileUsage", Tag: "Files", Summary: "Get the storage used under a prefix",
+			Description: "Counts the files and bytes, including noncurrent versions, under a directory prefix and the directories up to `depth` levels below it. The counts are kept up to date on every write, so no files are walked.",
+			Params: []openapi.Param{
+				openapi.Query("prefix", "string", "Directory prefix, such as `photos/2024` (default every file)"),
+				openapi.Query("depth", "integer", "Levels of directories below the prefix to list (default 0)"),
+			},
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The prefix, then the directories below it sorted by prefix", responses.FileUsageResponse{}), badRequest},
+		}},
+		{handler: f(s.FileEndpoints.HandleGetTenantUsage), Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/files/usage/tenants", ID: "getTenantUsage", Tag: "Files", Summary: "Get the storage used by each tenant",
+			Description: "The tenant of a file is its `tenant` metadata entry, or else its owner. Files without either only count under prefixes.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The tenants sorted by name", responses.TenantUsageResponse{})},
+		}},
+		{handler: f(s.FileEndpoints.HandleUploadFile), Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/files", ID: "uploadFile", Tag: "Files", Summary: "Upload a file",
+			Body: openapi.FormBody(map[string]*openapi.Schema{
+				"file":     {Type: "string", Format: "binary"},
+				"metadata": {Type: "string", Description: "JSON object of metadata values"},
+				"tags":     {Type: "string", Description: "Comma-separated tags"},
+				"path":     {Type: "string", Description: "Key to store the file under, replacing the file there; a path ending in / keeps the file name. A generated key when omitted."},
+			}, "file"),
+			Description: "If-Match and If-None-Match check the file the upload replaces, so concurrent writers do not lose each other's updates; `If-None-Match: *` only creates.",
+			Params:      writeConditions,
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusCreated, "The stored file", responses.FileResponse{}),
+				badRequest,
+				fileLocked,
+				fileChanged,
+				policyDenied,
+				storageFull,
+				scanRefused,
+				scanUnavailable,
+			},
+		}},
+		{handler: f(s.FileEndpoints.HandleDeleteFile), Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/files", ID: "deleteFile", Tag: "Files", Summary: "Delete a file",
+			Params: []openapi.Param{key, openapi.Header("If-Match", "ETags the file must have to be deleted")},
+			Responses: []openapi.Response{
+				openapi.Empty(http.StatusNoContent, "The file was deleted"),
+				badRequest,
+				openapi.Error(http.StatusLocked, "The file is under a retention lock or legal hold"),
+				fileChanged,
+			},
+		}},
+		{handler: f(s.FileEndpoints.HandleCopyObject), Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/files/copy", ID: "copyFile", Tag: "Files", Summary: "Copy a file to another key",
+			Description: "Copies on the server, so the content is not downloaded and uploaded again. `tenant` hands the copy to another tenant, which needs a policy whose `copy` rules allow it. An existing destination is only replaced with `overwrite`.",
+			Body:        openapi.JSONBody(requests.FileCopyRequest{}),
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusCreated, "The copy", responses.FileResponse{}),
+				badRequest,
+				notFound,
+				openapi.Error(http.StatusConflict, "A file exists at the destination"),
+				fileLocked,
+				policyDenied,
+				storageFull,
+			},
+		}},
+		{handler: f(s.FileEndpoints.HandleMoveObject), Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/files/move", ID: "moveFile", Tag: "Files", Summary: "Move or rename a file",
+			Description: "Renames the key on the server. `tenant` hands the file to another tenant, which needs a policy whose `move` rules allow it. Files under retention cannot be moved.",
+			Body:        openapi.JSONBody(requests.FileCopyRequest{}),
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusCreated, "The moved file", responses.FileResponse{}),
+				badRequest,
+				notFound,
+				openapi.Error(http.StatusConflict, "A file exists at the destination"),
+				fileLocked,
+				policyDenied,
+				storageFull,
+			},
+		}},
+		{handler: f(s.FileEndpoints.HandleComposeObject), Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/files/compose", ID: "composeFile", Tag: "Files", Summary: "Build a file from other files",
+			Description: "Concatenates up to 32 files, in the order given, into a new file on the server, so parts uploaded in parallel under separate keys are joined without being uploaded again. `delete_sources` deletes the parts once the file is stored.",
+			Body:        openapi.JSONBody(requests.FileComposeRequest{}),
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusCreated, "The composed file", responses.FileResponse{}),
+				badRequest,
+				openapi.Error(http.StatusNotFound, "A source file was not found"),
+				openapi.Error(http.StatusConflict, "A file exists at the destination"),
+				fileLocked,
+				policyDenied,
+				storageFull,
+				scanRefused,
+				scanUnavailable,
+			},
+		}},
+		{handler: f(s.BatchEndpoints.HandleBatch), Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/batch", ID: "executeBatch", Tag: "Files", Summary: "Run many file operations in one request",
+			Description: "Runs up to 1000 operations: `store` writes `content` (base64) or the content the server fetches from `url`, `delete` removes `key`, `copy` works like the copy endpoint and `tag` changes the tags and metadata of `key` like a metadata patch. Up to `parallelism` operations run at once, 8 by default and at most 32, in no particular order; a parallelism of 1 runs them in the order given. Each operation gets a result with the status it would have had on its own endpoint, and one failing does not stop the others. Malformed operations fail the whole batch before any of it runs.",
+			Body:        openapi.JSONBody(requests.BatchRequest{}),
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The result of every operation, in order", responses.BatchResponse{}),
+				badRequest,
+			},
+		}},
+		{handler: f(s.FileEndpoints.HandleUpdateFileMetadata), Operation: openapi.Operation{
+			Method: "PUT", Path: "/api/v1/files/metadata", ID: "updateFileMetadata", Tag: "Files", Summary: "Replace file metadata",
+			Params:    []openapi.Param{key},
+			Body:      openapi.JSONBody(requests.FileMetadataUpdateRequest{}),
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The updated file", responses.FileResponse{}), badRequest},
+		}},
+		{handler: f(s.FileEndpoints.HandlePatchFileMetadata), Operation: openapi.Operation{
+			Method: "PATCH", Path: "/api/v1/files/{key}/metadata", ID: "patchFileMetadata", Tag: "Files", Summary: "Patch file tags and metadata",
+			Body: openapi.JSONBody(requests.FileMetadataPatchRequest{}),
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The updated file", responses.FileResponse{}),
+				badRequest,
+				notFound,
+				openapi.Error(http.StatusServiceUnavailable, "Metadata is read-only on this node"),
+			},
+		}},
+		{handler: f(s.MediaEndpoints.HandleGetThumbnail), disabled: s.MediaEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/files/{key}/thumbnail", ID: "getFileThumbnail", Tag: "Files", Summary: "Get the thumbnail of an image or video",
+			Description: "A JPEG whose longest edge is `size` pixels, one of the sizes the server is configured with (64, 256 and 1024 by default). Thumbnails are rendered when the file is stored or on the first request, and stored as derived objects. Video thumbnails are poster frames grabbed with ffmpeg. Stored images and videos also carry their dimensions, and videos their duration, in `pv-media-*` metadata.",
+			Params:      []openapi.Param{openapi.Query("size", "integer", "Longest edge in pixels (default 256)")},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "The thumbnail", ContentType: "image/jpeg", Schema: &openapi.Schema{Type: "string", Format: "binary"}},
+				openapi.Empty(http.StatusNotModified, "The thumbnail matches If-None-Match"),
+				badRequest,
+				notFound,
+				mediaUnsupported,
+			},
+		}},
+		{handler: f(s.MediaEndpoints.HandleGetPlaylist), disabled: s.MediaEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/files/{key}/stream/master.m3u8", ID: "getFileStream", Tag: "Files", Summary: "Get the HLS playlist of a video or audio file",
+			Description: "Lists the transcoding profiles the file is streamed in, each at `{profile}/index.m3u8` next to this playlist. Profiles taller than a video are left out. Point a player such as hls.js or a Safari `<video>` element at this URL. Streaming needs the server to be configured with ffmpeg.",
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "The master playlist", ContentType: media.PlaylistType, Schema: &openapi.Schema{Type: "string"}},
+				openapi.Empty(http.StatusNotModified, "The playlist matches If-None-Match"),
+				notFound,
+				mediaUnsupported,
+			},
+		}},
+		{handler: f(s.MediaEndpoints.HandleGetPlaylist), disabled: s.MediaEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/files/{key}/stream/{profile}/index.m3u8", ID: "getFileStreamProfile", Tag: "Files", Summary: "Get the HLS playlist of a stream profile",
+			Description: "Lists the segments of the file in the profile, each at `{n}.ts`.",
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "The media playlist", ContentType: media.PlaylistType, Schema: &openapi.Schema{Type: "string"}},
+				openapi.Empty(http.StatusNotModified, "The playlist matches If-None-Match"),
+				streamNotFound,
+				mediaUnsupported,
+			},
+		}},
+		{handler: f(s.MediaEndpoints.HandleGetSegment), disabled: s.MediaEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/files/{key}/stream/{profile}/{segment}", ID: "getFileStreamSegment", Tag: "Files", Summary: "Get a segment of a stream",
+			Description: "An MPEG-TS segment such as `3.ts`, transcoded with ffmpeg on the first request and stored as a derived object. Supports Range requests.",
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "The segment", ContentType: media.SegmentType, Schema: &openapi.Schema{Type: "string", Format: "binary"}},
+				{Status: http.StatusPartialContent, Description: "The requested range", ContentType: media.SegmentType, Schema: &openapi.Schema{Type: "string", Format: "binary"}},
+				openapi.Empty(http.StatusNotModified, "The segment matches If-None-Match"),
+				streamNotFound,
+				mediaUnsupported,
+			},
+		}},
+		{handler: f(s.ReplicaEndpoints.HandleGetReplicas), disabled: s.ReplicaEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/files/{key}/replicas", ID: "getFileReplicas", Tag: "Files", Summary: "Get the replicas of a file",
+			Description: "Asks every connected peer for its copy. Peers that held a copy at an earlier check and no longer have it, or did not answer, are listed as missing or unreachable.",
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The copies and whether the replication factor is met", fileserver.ReplicaReport{}),
+				notFound,
+			},
+		}},
+
+		// Directories
+		{handler: f(s.DirectoryEndpoints.HandleListDirectory), disabled: s.DirectoryEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/directories/{path...}", ID: "listDirectory", Tag: "Directories", Summary: "List a folder",
+			Description: "Folders are inferred from the `/` separated segments of keys, or marked by directory objects (keys ending in `/`). An empty path lists the root.",
+			Params:      []openapi.Param{recursive},
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The files and folders", directory.Listing{}), badRequest, dirNotFound},
+		}},
+		{handler: f(s.DirectoryEndpoints.HandleMakeDirectory), disabled: s.DirectoryEndpoints == nil, Operation: openapi.Operation{
+			Method: "PUT", Path: "/api/v1/directories/{path...}", ID: "makeDirectory", Tag: "Directories", Summary: "Create a folder",
+			Description: "Stores a directory object, so the folder is listed while empty. Creating a folder that exists succeeds.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusCreated, "The folder", directory.Directory{}), badRequest},
+		}},
+		{handler: f(s.DirectoryEndpoints.HandleDeleteDirectory), disabled: s.DirectoryEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/directories/{path...}", ID: "deleteDirectory", Tag: "Directories", Summary: "Delete a file or folder",
+			Description: "A folder holding files is only deleted with `recursive=true`. Files under retention are kept and reported as failed.",
+			Params:      []openapi.Param{recursive},
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "What was deleted", directory.Report{}),
+				badRequest,
+				dirNotFound,
+				openapi.Error(http.StatusConflict, "The folder is not empty"),
+			},
+		}},
+		{handler: f(s.DirectoryEndpoints.HandleCopy), disabled: s.DirectoryEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/directories/copy", ID: "copyDirectory", Tag: "Directories", Summary: "Copy a file or folder",
+			Description: "Copies on the server. Keys that exist at the destination are skipped unless `overwrite` is set.",
+			Body:        openapi.JSONBody(requests.DirectoryTransferRequest{}),
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "What was copied", directory.Report{}), badRequest, dirNotFound, fileLocked},
+		}},
+		{handler: f(s.DirectoryEndpoints.HandleMove), disabled: s.DirectoryEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/directories/move", ID: "moveDirectory", Tag: "Directories", Summary: "Move or rename a file or folder",
+			Description: "Renames keys on the server; content is not uploaded again. Keys that exist at the destination are skipped unless `overwrite` is set.",
+			Body:        openapi.JSONBody(requests.DirectoryTransferRequest{}),
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "What was moved", directory.Report{}), badRequest, dirNotFound, fileLocked},
+		}},
+
+		// Conflicts
+		{handler: f(s.ConflictEndpoints.HandleListConflicts), disabled: s.ConflictEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/conflicts", ID: "listConflicts", Tag: "Conflicts", Summary: "List files with conflicting versions",
+			Description: "Files written concurrently on different nodes keep every version until one is picked or merged. Only the conflicts found by this node are listed; files it holds a replica of are listed by hashed key.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The conflicts", responses.ConflictListResponse{})},
+		}},
+		{handler: f(s.ConflictEndpoints.HandleGetVersions), disabled: s.ConflictEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/files/{key}/versions", ID: "getFileVersions", Tag: "Conflicts", Summary: "Get the versions of a file",
+			Description: "The key may also be the hashed key listed by listConflicts.",
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The current version first, then the conflicting ones", fileserver.FileVersions{}),
+				versionNotFound,
+			},
+		}},
+		{handler: f(s.ConflictEndpoints.HandleGetVersionContent), disabled: s.ConflictEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/files/{key}/versions/{id}/content", ID: "downloadFileVersion", Tag: "Conflicts", Summary: "Download a version of a file",
+			Responses: []openapi.Response{openapi.Binary(http.StatusOK, "The content of the version"), versionNotFound},
+		}},
+		{handler: f(s.ConflictEndpoints.HandlePickVersion), disabled: s.ConflictEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/files/{key}/versions/{id}/pick", ID: "pickFileVersion", Tag: "Conflicts", Summary: "Resolve a conflict by picking a version",
+			Description: "The picked content gets a version descending from every conflicting one and replaces the copies on the file's owners.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The new current version", fileserver.FileVersion{}), versionNotFound, noConflict},
+		}},
+		{handler: f(s.ConflictEndpoints.HandleMergeVersions), disabled: s.ConflictEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/files/{key}/versions/merge", ID: "mergeFileVersions", Tag: "Conflicts", Summary: "Resolve a conflict with merged content",
+			Description: "The body replaces every conflicting version, like a picked version does.",
+			Body:        openapi.BinaryBody(),
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The new current version", fileserver.FileVersion{}), versionNotFound, noConflict},
+		}},
+
+		// Shared documents
+		{handler: f(s.DocumentEndpoints.HandleListDocuments), disabled: s.DocumentEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/documents", ID: "listDocuments", Tag: "Documents", Summary: "List shared documents",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The document names", responses.DocumentListResponse{})},
+		}},
+		{handler: f(s.DocumentEndpoints.HandleGetDocument), disabled: s.DocumentEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/documents/{name}", ID: "getDocument", Tag: "Documents", Summary: "Get a shared document",
+			Description: "Returns this node's copy, which includes the changes gossiped to it so far.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The fields and sets of the document", crdt.View{}), documentNotFound},
+		}},
+		{handler: f(s.DocumentEndpoints.HandleUpdateDocument), disabled: s.DocumentEndpoints == nil, Operation: openapi.Operation{
+			Method: "PATCH", Path: "/api/v1/documents/{name}", ID: "updateDocument", Tag: "Documents", Summary: "Update a shared document",
+			Description: "Creates the document when it does not exist. The change is sent to every peer; fields take the value of their latest write, and set elements added concurrently with their removal stay.",
+			Body:        openapi.JSONBody(requests.DocumentUpdateRequest{}),
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The updated document", crdt.View{}),
+				badRequest,
+				openapi.Error(http.StatusRequestEntityTooLarge, "The document would grow past 1 MiB"),
+			},
+		}},
+
+		// JSON documents
+		{handler: f(s.JSONEndpoints.HandleGetJSON), disabled: s.JSONEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/json/{key}", ID: "getJSON", Tag: "JSON", Summary: "Get a JSON document",
+			Description: "The ETag is the hash of the document; send it back in If-Match to update the document only if nobody changed it since.",
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "The document", ContentType: jsondoc.ContentType, Schema: anyJSON},
+				openapi.Empty(http.StatusNotModified, "The document matches If-None-Match"),
+				jsonNotFound,
+				notJSON,
+			},
+		}},
+		{handler: f(s.JSONEndpoints.HandlePutJSON), disabled: s.JSONEndpoints == nil, Operation: openapi.Operation{
+			Method: "PUT", Path: "/api/v1/json/{key}", ID: "putJSON", Tag: "JSON", Summary: "Create or replace a JSON document",
+			Description: "Documents of up to 1 MiB are stored as files of type application/json, compacted with their object keys sorted. `If-None-Match: *` only creates the document; `If-Match` only replaces it if it has that ETag. Documents below a key prefix bound to a JSON Schema must match it.",
+			Params:      jsonConditions,
+			Body:        &openapi.Body{ContentType: jsondoc.ContentType, Schema: anyJSON},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "The replaced document as stored", ContentType: jsondoc.ContentType, Schema: anyJSON},
+				{Status: http.StatusCreated, Description: "The created document as stored", ContentType: jsondoc.ContentType, Schema: anyJSON},
+				badRequest,
+				policyDenied,
+				storageFull,
+				notJSON,
+				preconditionFailed,
+				openapi.Error(http.StatusRequestEntityTooLarge, "The document is larger than 1 MiB"),
+				schemaMismatch,
+				fileLocked,
+			},
+		}},
+		{handler: f(s.JSONEndpoints.HandlePatchJSON), disabled: s.JSONEndpoints == nil, Operation: openapi.Operation{
+			Method: "PATCH", Path: "/api/v1/json/{key}", ID: "patchJSON", Tag: "JSON", Summary: "Patch a JSON document",
+			Description: "Applies a JSON Patch (RFC 6902, `application/json-patch+json`) or a JSON Merge Patch (RFC 7396, `application/merge-patch+json`). A patch applies entirely or not at all; a failed `test` operation returns 409.",
+			Params:      jsonConditions,
+			Body:        &openapi.Body{ContentType: jsondoc.PatchType, Schema: &openapi.Schema{Type: "array", Items: &openapi.Schema{Type: "object"}}},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "The patched document", ContentType: jsondoc.ContentType, Schema: anyJSON},
+				badRequest,
+				policyDenied,
+				storageFull,
+				jsonNotFound,
+				openapi.Error(http.StatusConflict, "A test operation of the patch failed"),
+				preconditionFailed,
+				openapi.Error(http.StatusUnsupportedMediaType, "The body is not a JSON Patch or JSON Merge Patch, or the file is not JSON"),
+				openapi.Error(http.StatusUnprocessableEntity, "The patch cannot be applied, or the result does not match the schema"),
+				fileLocked,
+			},
+		}},
+		{handler: f(s.JSONEndpoints.HandleDeleteJSON), disabled: s.JSONEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/json/{key}", ID: "deleteJSON", Tag: "JSON", Summary: "Delete a JSON document",
+			Params: jsonConditions,
+			Responses: []openapi.Response{
+				openapi.Empty(http.StatusNoContent, "The document was deleted"),
+				jsonNotFound,
+				notJSON,
+				preconditionFailed,
+				fileLocked,
+			},
+		}},
+
+		// Append-only logs
+		{handler: f(s.LogEndpoints.HandleListLogs), disabled: s.LogEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/logs", ID: "listLogs", Tag: "Logs", Summary: "List append-only logs",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The logs", responses.LogListResponse{})},
+		}},
+		{handler: f(s.LogEndpoints.HandleGetLog), disabled: s.LogEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/logs/{name}", ID: "getLog", Tag: "Logs", Summary: "Describe a log",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The offsets and size of the log", streamlog.Info{}), logNotFound},
+		}},
+		{handler: f(s.LogEndpoints.HandleDeleteLog), disabled: s.LogEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/logs/{name}", ID: "deleteLog", Tag: "Logs", Summary: "Delete a log and its records",
+			Responses: []openapi.Response{openapi.Empty(http.StatusNoContent, "The log was deleted"), logNotFound},
+		}},
+		{handler: f(s.LogEndpoints.HandleAppendRecords), disabled: s.LogEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/logs/{name}/records", ID: "appendLogRecords", Tag: "Logs", Summary: "Append records to a log",
+			Description: "The body is one record, or one record per line when its content type is application/x-ndjson. The log is created by its first append. Records are up to 1 MiB and are stored in segments replicated like files.",
+			Body:        openapi.BinaryBody(),
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusCreated, "The offsets and times of the records, without their data", responses.LogRecordsResponse{}),
+				badRequest,
+				openapi.Error(http.StatusRequestEntityTooLarge, "A record is larger than 1 MiB, or the body larger than 16 MiB"),
+				storageFull,
+			},
+		}},
+		{handler: f(s.LogEndpoints.HandleReadRecords), disabled: s.LogEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/logs/{name}/records", ID: "readLogRecords", Tag: "Logs", Summary: "Read the records of a log",
+			Description: "Records are returned oldest first, with their data base64-encoded. Reading from an offset retention dropped starts at the oldest record kept. With follow=true the records are streamed as NDJSON, followed by new ones as they are appended.",
+			Params: []openapi.Param{
+				openapi.Query("offset", "integer", "First offset to read (default 0)"),
+				openapi.Query("since", "string", "RFC 3339 time; older records are skipped"),
+				openapi.Query("limit", "integer", "Maximum number of records, up to 1000 (default 1000)"),
+				openapi.Query("follow", "boolean", "Stream records as they are appended"),
+			},
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The records and the offset to read from next", responses.LogRecordsResponse{}),
+				badRequest,
+				logNotFound,
+			},
+		}},
+		{handler: f(s.LogEndpoints.HandleTruncateLog), disabled: s.LogEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/logs/{name}/records", ID: "truncateLog", Tag: "Logs", Summary: "Drop the oldest records of a log",
+			Description: "Drops whole segments whose records are all before the offset or older than the time. The newest segment is always kept.",
+			Params: []openapi.Param{
+				openapi.Query("before_offset", "integer", "Drop records before this offset"),
+				openapi.Query("before_time", "string", "RFC 3339 time; drop records older than this"),
+			},
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The log after truncation", streamlog.Info{}), badRequest, logNotFound},
+		}},
+
+		// Access analytics
+		{handler: f(s.AnalyticsEndpoints.HandleGetRollups), disabled: s.AnalyticsEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/analytics/access", ID: "getAccessRollups", Tag: "Analytics", Summary: "Get access rollups",
+			Description: "Counts the reads, writes and deletes this node served per key, tenant or peer, in buckets of bucket_seconds. Copies received from peers are counted under their hashed key.",
+			Params:      accessParams,
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The rollups, oldest first", responses.AccessRollupsResponse{}), badRequest},
+		}},
+		{handler: f(s.AnalyticsEndpoints.HandleGetTop), disabled: s.AnalyticsEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/analytics/access/top", ID: "getTopAccessed", Tag: "Analytics", Summary: "Get the most accessed keys, tenants or peers",
+			Params:    append(slices.Clip(accessParams), openapi.Query("limit", "integer", "Maximum number of results (default 10)")),
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The totals, most accessed first", responses.AccessTopResponse{}), badRequest},
+		}},
+
+		// Analytics reports
+		{handler: f(s.ReportEndpoints.HandleListReports), disabled: s.ReportEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/analytics/reports", ID: "listReports", Tag: "Analytics", Summary: "List analytics reports",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The reports with their last and next run", responses.ReportListResponse{})},
+		}},
+		{handler: f(s.ReportEndpoints.HandleGetReport), disabled: s.ReportEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/analytics/reports/{name}", ID: "getReport", Tag: "Analytics", Summary: "Get an analytics report",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The report with its last and next run", analytics.ReportInfo{}), reportNotFound},
+		}},
+		{handler: f(s.ReportEndpoints.HandlePutReport), disabled: s.ReportEndpoints == nil, Operation: openapi.Operation{
+			Method: "PUT", Path: "/api/v1/analytics/reports/{name}", ID: "putReport", Tag: "Analytics", Summary: "Create or replace an analytics report",
+			Description: "A report with a cron schedule runs on this node at those times; its output is stored under `<prefix><name>/<time>.<format>` and sent to its webhook and email recipients.",
+			Body:        openapi.JSONBody(requests.ReportRequest{}),
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The report with its defaults filled in", analytics.ReportInfo{}), badRequest},
+		}},
+		{handler: f(s.ReportEndpoints.HandleDeleteReport), disabled: s.ReportEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/analytics/reports/{name}", ID: "deleteReport", Tag: "Analytics", Summary: "Delete an analytics report",
+			Description: "The outputs the report stored are kept.",
+			Responses:   []openapi.Response{openapi.Empty(http.StatusNoContent, "The report was deleted"), reportNotFound},
+		}},
+		{handler: f(s.ReportEndpoints.HandleRunReport), disabled: s.ReportEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/analytics/reports/{name}/run", ID: "runReport", Tag: "Analytics", Summary: "Run an analytics report now",
+			Description: "Failed webhook or email deliveries are listed in the run; the output is stored regardless.",
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "Where the output was stored", analytics.ReportRun{}),
+				reportNotFound,
+				openapi.Error(http.StatusInternalServerError, "The output could not be stored"),
+			},
+		}},
+
+		// Alerts
+		{handler: f(s.AlertEndpoints.HandleListAlerts), disabled: s.AlertEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/alerts", ID: "listAlerts", Tag: "Alerts", Summary: "List the state of every alert rule",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The alerts by rule name", responses.AlertListResponse{})},
+		}},
+		{handler: f(s.AlertEndpoints.HandleListRules), disabled: s.AlertEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/alerts/rules", ID: "listAlertRules", Tag: "Alerts", Summary: "List alert rules",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The rules", responses.AlertRuleListResponse{})},
+		}},
+		{handler: f(s.AlertEndpoints.HandlePutRule), disabled: s.AlertEndpoints == nil, Operation: openapi.Operation{
+			Method: "PUT", Path: "/api/v1/alerts/rules/{name}", ID: "putAlertRule", Tag: "Alerts", Summary: "Create or replace an alert rule",
+			Description: "Threshold rules compare the latest value of a metric, rate rules its change per second over the window, and absence rules fire when the metric was not reported for the window. A replaced rule keeps its alert state.",
+			Body:        openapi.JSONBody(requests.AlertRuleRequest{}),
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The rule with its defaults filled in", alerting.Rule{}), badRequest},
+		}},
+		{handler: f(s.AlertEndpoints.HandleDeleteRule), disabled: s.AlertEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/alerts/rules/{name}", ID: "deleteAlertRule", Tag: "Alerts", Summary: "Delete an alert rule",
+			Description: "A firing alert of the rule is dropped without a resolved notification.",
+			Responses:   []openapi.Response{openapi.Empty(http.StatusNoContent, "The rule was deleted"), alertNotFound},
+		}},
+		{handler: f(s.AlertEndpoints.HandleListChannels), disabled: s.AlertEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/alerts/channels", ID: "listAlertChannels", Tag: "Alerts", Summary: "List notification channels",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The channels", responses.AlertChannelListResponse{})},
+		}},
+		{handler: f(s.AlertEndpoints.HandlePutChannel), disabled: s.AlertEndpoints == nil, Operation: openapi.Operation{
+			Method: "PUT", Path: "/api/v1/alerts/channels/{name}", ID: "putAlertChannel", Tag: "Alerts", Summary: "Create or replace a notification channel",
+			Description: "Webhook channels receive JSON, Slack channels Slack-compatible `{\"text\": ...}` messages, and email channels mail through the node's SMTP server.",
+			Body:        openapi.JSONBody(requests.AlertChannelRequest{}),
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The channel", alerting.Channel{}), badRequest},
+		}},
+		{handler: f(s.AlertEndpoints.HandleDeleteChannel), disabled: s.AlertEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/alerts/channels/{name}", ID: "deleteAlertChannel", Tag: "Alerts", Summary: "Delete a notification channel",
+			Responses: []openapi.Response{
+				openapi.Empty(http.StatusNoContent, "The channel was deleted"),
+				alertNotFound,
+				openapi.Error(http.StatusBadRequest, "A rule uses the channel"),
+			},
+		}},
+		{handler: f(s.AlertEndpoints.HandleListSilences), disabled: s.AlertEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/alerts/silences", ID: "listSilences", Tag: "Alerts", Summary: "List the silences that have not ended",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The silences", responses.SilenceListResponse{})},
+		}},
+		{handler: f(s.AlertEndpoints.HandleAddSilence), disabled: s.AlertEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/alerts/silences", ID: "addSilence", Tag: "Alerts", Summary: "Silence the notifications of a rule or of every rule",
+			Description: "Silenced alerts keep being evaluated; only their notifications are muted.",
+			Body:        openapi.JSONBody(requests.SilenceRequest{}),
+			Responses:   []openapi.Response{openapi.JSON(http.StatusCreated, "The silence with its ID", alerting.Silence{}), badRequest, alertNotFound},
+		}},
+		{handler: f(s.AlertEndpoints.HandleDeleteSilence), disabled: s.AlertEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/alerts/silences/{id}", ID: "deleteSilence", Tag: "Alerts", Summary: "End a silence early",
+			Responses: []openapi.Response{openapi.Empty(http.StatusNoContent, "The silence was deleted"), alertNotFound},
+		}},
+		{handler: f(s.AlertEndpoints.HandleListMetrics), disabled: s.AlertEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/alerts/metrics", ID: "listAlertMetrics", Tag: "Alerts", Summary: "List the metrics alert rules can refer to",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The latest value of every metric", responses.MetricListResponse{})},
+		}},
+		{handler: f(s.AlertEndpoints.HandlePushMetrics), disabled: s.AlertEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/alerts/metrics", ID: "pushAlertMetrics", Tag: "Alerts", Summary: "Push metric values",
+			Description: "Pushed values are evaluated with the node's own metrics, e.g. the heartbeats of jobs watched by absence rules.",
+			Body:        openapi.JSONBody(requests.MetricsRequest{}),
+			Responses:   []openapi.Response{openapi.Empty(http.StatusNoContent, "The values were recorded"), badRequest},
+		}},
+
+		// Grafana JSON datasource
+		{handler: f(s.GrafanaEndpoints.HandleTest), disabled: s.GrafanaEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/grafana/{$}", ID: "testGrafanaDatasource", Tag: "Grafana", Summary: "Test the datasource connection",
+			Description: "Point a Grafana JSON datasource at `/api/v1/grafana`.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The datasource is reachable", map[string]string{})},
+		}},
+		{handler: f(s.GrafanaEndpoints.HandleSearch), disabled: s.GrafanaEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/grafana/search", ID: "searchGrafanaMetrics", Tag: "Grafana", Summary: "Search metrics or label values",
+			Description: "Lists the metrics whose name contains the target. A target naming a label (`node`, `key`, `tenant` or `peer`) lists its values instead, for template variables.",
+			Body:        openapi.JSONBody(requests.GrafanaSearchRequest{}),
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "Metric names or label values", []string{}), badRequest},
+		}},
+		{handler: f(s.GrafanaEndpoints.HandleMetrics), disabled: s.GrafanaEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/grafana/metrics", ID: "listGrafanaMetrics", Tag: "Grafana", Summary: "List metrics for the query editor",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The metrics", []responses.GrafanaMetric{})},
+		}},
+		{handler: f(s.GrafanaEndpoints.HandleQuery), disabled: s.GrafanaEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/grafana/query", ID: "queryGrafana", Tag: "Grafana", Summary: "Query time series and tables",
+			Description: "Targets are metric names with optional label selectors, e.g. `access_reads{tenant=\"*\"}`. Node metrics are sampled every 15 seconds and kept for a day; `access_*` metrics are the hourly access rollups.",
+			Body:        openapi.JSONBody(grafana.QueryRequest{}),
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "A series per selected metric and label values, or a table per table target", []grafana.Series{}), badRequest},
+		}},
+		{handler: f(s.GrafanaEndpoints.HandleTagKeys), disabled: s.GrafanaEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/grafana/tag-keys", ID: "listGrafanaTagKeys", Tag: "Grafana", Summary: "List the labels of ad hoc filters",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The labels", []responses.GrafanaTagKey{})},
+		}},
+		{handler: f(s.GrafanaEndpoints.HandleTagValues), disabled: s.GrafanaEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/grafana/tag-values", ID: "listGrafanaTagValues", Tag: "Grafana", Summary: "List the values of an ad hoc filter label",
+			Body:      openapi.JSONBody(requests.GrafanaTagValuesRequest{}),
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The values", []responses.GrafanaTagValue{}), badRequest},
+		}},
+
+		// Retention locks
+		{handler: f(s.RetentionEndpoints.HandleGetLock), Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/files/{key}/lock", ID: "getFileLock", Tag: "Locks", Summary: "Get the lock of a file",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The lock", responses.FileLockResponse{}), notFound},
+		}},
+		{handler: f(s.RetentionEndpoints.HandleUpdateLock), Operation: openapi.Operation{
+			Method: "PUT", Path: "/api/v1/files/{key}/lock", ID: "updateFileLock", Tag: "Locks", Summary: "Update the lock of a file",
+			Description: "Retention can only be extended; legal holds can be placed and released.",
+			Body:        openapi.JSONBody(requests.FileLockUpdateRequest{}),
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The updated lock", responses.FileLockResponse{}),
+				badRequest,
+				notFound,
+				openapi.Error(http.StatusConflict, "The change would shorten retention"),
+			},
+		}},
+		{handler: f(s.RetentionEndpoints.HandleListLocks), Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/locks", ID: "listFileLocks", Tag: "Locks", Summary: "List file locks",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The locks", responses.FileLockListResponse{})},
+		}},
+
+		// Distributed locks and leases
+		{handler: f(s.LeaseEndpoints.HandleListLeases), disabled: s.LeaseEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/leases", ID: "listLeases", Tag: "Leases", Summary: "List leases",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The leases that have not expired", responses.LeaseListResponse{}), leaseUnavailable},
+		}},
+		{handler: f(s.LeaseEndpoints.HandleGetLease), disabled: s.LeaseEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/leases/{name}", ID: "getLease", Tag: "Leases", Summary: "Get a lease",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The lease", consensus.Lease{}), leaseNotFound, leaseUnavailable},
+		}},
+		{handler: f(s.LeaseEndpoints.HandleAcquireLease), disabled: s.LeaseEndpoints == nil, Operation: openapi.Operation{
+			Method: "PUT", Path: "/api/v1/leases/{name}", ID: "acquireLease", Tag: "Leases", Summary: "Acquire or renew a lease",
+			Description: "Grants the lease to the holder for ttl_seconds. The holder renews it by acquiring it again before it expires, " +
+				"which keeps its token; a new holder gets a larger token. Pass the token along with writes so stale holders can be fenced off.",
+			Body: openapi.JSONBody(requests.LeaseAcquireRequest{}),
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The lease and its fencing token", consensus.Lease{}),
+				badRequest,
+				openapi.Error(http.StatusConflict, "Another holder holds the lease"),
+				leaseUnavailable,
+			},
+		}},
+		{handler: f(s.LeaseEndpoints.HandleReleaseLease), disabled: s.LeaseEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/leases/{name}", ID: "releaseLease", Tag: "Leases", Summary: "Release a lease",
+			Params: []openapi.Param{openapi.RequiredQuery("holder", "string", "Holder of the lease")},
+			Responses: []openapi.Response{
+				openapi.Empty(http.StatusNoContent, "The lease was released"),
+				badRequest,
+				leaseNotFound,
+				openapi.Error(http.StatusConflict, "Another holder holds the lease"),
+				leaseUnavailable,
+			},
+		}},
+
+		// Peers
+		{handler: f(s.PeerEndpoints.HandleListPeers), Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/peers", ID: "listPeers", Tag: "Peers", Summary: "List peers",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The peers", responses.PeerListResponse{})},
+		}},
+		{handler: f(s.PeerEndpoints.HandleGetPeer), Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/peers/get", ID: "getPeer", Tag: "Peers", Summary: "Get a peer",
+			Params: []openapi.Param{openapi.RequiredQuery("id", "string", "ID of the peer")},
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The peer", responses.PeerResponse{}),
+				badRequest,
+				openapi.Error(http.StatusNotFound, "Peer not found"),
+			},
+		}},
+		{handler: f(s.PeerEndpoints.HandleAddPeer), Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/peers", ID: "addPeer", Tag: "Peers", Summary: "Add a peer",
+			Body:      openapi.JSONBody(requests.PeerAddRequest{}),
+			Responses: []openapi.Response{openapi.JSON(http.StatusCreated, "The added peer", responses.PeerResponse{}), badRequest},
+		}},
+		{handler: f(s.PeerEndpoints.HandleRemovePeer), Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/peers", ID: "removePeer", Tag: "Peers", Summary: "Remove a peer",
+			Params:    []openapi.Param{openapi.RequiredQuery("id", "string", "ID of the peer")},
+			Responses: []openapi.Response{openapi.Empty(http.StatusNoContent, "The peer was removed"), badRequest},
+		}},
+		{handler: f(s.PeerACLEndpoints.HandleListRules), disabled: s.PeerACLEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/peers/acl", ID: "listPeerACLRules", Tag: "Peers", Summary: "List peer ACL rules",
+			Description: "Rules of the configuration come first. Deny rules win over allow rules; once there is an allow rule, only peers matching one may connect.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The rules", responses.PeerACLRuleListResponse{})},
+		}},
+		{handler: f(s.PeerACLEndpoints.HandleAddRule), disabled: s.PeerACLEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/peers/acl", ID: "addPeerACLRule", Tag: "Peers", Summary: "Add a peer ACL rule",
+			Description: "The rule matches peers meeting every criterion it sets. Connected peers the rules now keep out are disconnected.",
+			Body:        openapi.JSONBody(requests.PeerACLRuleRequest{}),
+			Responses:   []openapi.Response{openapi.JSON(http.StatusCreated, "The rule", acl.Rule{}), badRequest},
+		}},
+		{handler: f(s.PeerACLEndpoints.HandleRemoveRule), disabled: s.PeerACLEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/peers/acl/{id}", ID: "removePeerACLRule", Tag: "Peers", Summary: "Remove a peer ACL rule",
+			Responses: []openapi.Response{
+				openapi.Empty(http.StatusNoContent, "The rule was removed"),
+				openapi.Error(http.StatusNotFound, "Rule not found"),
+				openapi.Error(http.StatusConflict, "The rule comes from the configuration"),
+			},
+		}},
+		{handler: f(s.JoinTokenEndpoints.HandleListTokens), disabled: s.JoinTokenEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/peers/join-tokens", ID: "listJoinTokens", Tag: "Peers", Summary: "List join tokens",
+			Description: "The tokens and the nodes that joined with them. The tokens themselves are only returned when created.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The tokens", responses.JoinTokenListResponse{})},
+		}},
+		{handler: f(s.JoinTokenEndpoints.HandleCreateToken), disabled: s.JoinTokenEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/peers/join-tokens", ID: "createJoinToken", Tag: "Peers", Summary: "Create a join token",
+			Description: "A new node configured with the token presents it in the handshake with this node, which sends it the cluster credentials when the peer ACL lets it in.",
+			Body:        openapi.JSONBody(requests.JoinTokenRequest{}),
+			Responses:   []openapi.Response{openapi.JSON(http.StatusCreated, "The token", responses.JoinTokenResponse{}), badRequest},
+		}},
+		{handler: f(s.JoinTokenEndpoints.HandleRevokeToken), disabled: s.JoinTokenEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/peers/join-tokens/{id}", ID: "revokeJoinToken", Tag: "Peers", Summary: "Revoke a join token",
+			Description: "Nodes that joined with the token stay members.",
+			Responses: []openapi.Response{
+				openapi.Empty(http.StatusNoContent, "The token was revoked"),
+				openapi.Error(http.StatusNotFound, "Token not found"),
+			},
+		}},
+		{handler: f(s.DecommissionEndpoints.HandleGetStatus), disabled: s.DecommissionEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/peers/decommission", ID: "getDecommission", Tag: "Peers", Summary: "Get the decommission progress",
+			Description: "Whether the node is a member of the cluster, leaving it or has left, with the number of files moved to the remaining nodes.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The phase and progress", fileserver.DecommissionStatus{})},
+		}},
+		{handler: f(s.DecommissionEndpoints.HandleStart), disabled: s.DecommissionEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/peers/decommission", ID: "startDecommission", Tag: "Peers", Summary: "Decommission the node",
+			Description: "Takes the node off the hash ring of every peer and copies each local file to its owners among the remaining nodes until their replication factors are met. The node then disconnects from its peers and can be stopped; local copies are kept.",
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusAccepted, "The decommission started", fileserver.DecommissionStatus{}),
+				openapi.Error(http.StatusConflict, "The node is already leaving, or has no other node to move files to"),
+			},
+		}},
+		{handler: f(s.DecommissionEndpoints.HandleAbort), disabled: s.DecommissionEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/peers/decommission", ID: "abortDecommission", Tag: "Peers", Summary: "Abort the decommission",
+			Description: "Stops moving files and puts the node back on the hash ring. Copies already made stay where they are until rebalancing drops them.",
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The decommission was aborted", fileserver.DecommissionStatus{}),
+				openapi.Error(http.StatusConflict, "The node is not moving its files"),
+			},
+		}},
+		{handler: f(s.TopologyEndpoints.HandleGetTopology), disabled: s.TopologyEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/peers/topology", ID: "getPeerTopology", Tag: "Peers", Summary: "Get the peer latency map",
+			Description: "Round trip times measured by periodic probes from this node to its peers, and reported by the peers to theirs.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The nodes, links and latency matrix", peer.Topology{})},
+		}},
+
+		// Share links
+		{handler: f(s.ShareEndpoints.HandleCreateLink), Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/shares", ID: "createShareLink", Tag: "Shares", Summary: "Create a share link",
+			Body:      openapi.JSONBody(requests.ShareLinkCreateRequest{}),
+			Responses: []openapi.Response{openapi.JSON(http.StatusCreated, "The link", responses.ShareLinkResponse{}), badRequest, policyDenied, notFound},
+		}},
+		{handler: f(s.ShareEndpoints.HandleListLinks), Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/shares", ID: "listShareLinks", Tag: "Shares", Summary: "List share links",
+			Params:    []openapi.Param{openapi.Query("key", "string", "Only list the links of this file")},
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The links", responses.ShareLinkListResponse{})},
+		}},
+		{handler: f(s.ShareEndpoints.HandleGetLink), Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/shares/{id}", ID: "getShareLink", Tag: "Shares", Summary: "Get a share link",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The link", responses.ShareLinkResponse{}), openapi.Error(http.StatusNotFound, "Share link not found")},
+		}},
+		{handler: f(s.ShareEndpoints.HandleRevokeLink), Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/shares/{id}", ID: "revokeShareLink", Tag: "Shares", Summary: "Revoke a share link",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The revoked link", responses.ShareLinkResponse{}), openapi.Error(http.StatusNotFound, "Share link not found")},
+		}},
+		{handler: f(s.ShareEndpoints.HandleOpenLink), Operation: openapi.Operation{
+			Method: "GET", Path: sharing.PathPrefix + "{id}", ID: "openShareLink", Tag: "Shares", Summary: "Download a shared file",
+			Description: "Share links authenticate through their signature.",
+			Public:      true,
+			Params: []openapi.Param{
+				openapi.RequiredQuery("exp", "string", "Expiry of the link"),
+				openapi.RequiredQuery("sig", "string", "Signature of the link"),
+				openapi.Header(endpoints.SharePasswordHeader, "Password of a protected link"),
+			},
+			Responses: []openapi.Response{
+				openapi.Binary(http.StatusOK, "The file content"),
+				openapi.Error(http.StatusUnauthorized, "Password required"),
+				openapi.Error(http.StatusForbidden, "Invalid share link"),
+				openapi.Error(http.StatusNotFound, "Share link not found"),
+				openapi.Error(http.StatusGone, "Share link is no longer available"),
+			},
+		}},
+
+		// Resumable uploads
+		{handler: f(s.UploadEndpoints.HandleCreateUpload), disabled: s.UploadEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/uploads", ID: "createUpload", Tag: "Uploads", Summary: "Start a resumable upload",
+			Body:      openapi.JSONBody(requests.UploadCreateRequest{}),
+			Responses: []openapi.Response{openapi.JSON(http.StatusCreated, "The upload", responses.UploadResponse{}), badRequest},
+		}},
+		{handler: f(s.UploadEndpoints.HandleGetUpload), disabled: s.UploadEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/uploads/{id}", ID: "getUpload", Tag: "Uploads", Summary: "Get an upload and its received parts",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The upload", responses.UploadResponse{}), openapi.Error(http.StatusNotFound, "Upload not found")},
+		}},
+		{handler: f(s.UploadEndpoints.HandleUploadPart), disabled: s.UploadEndpoints == nil, Operation: openapi.Operation{
+			Method: "PUT", Path: "/api/v1/uploads/{id}/parts/{part}", ID: "uploadPart", Tag: "Uploads", Summary: "Upload a part",
+			Params: []openapi.Param{
+				openapi.Path("part", "integer", "Part number, counting from 0"),
+				openapi.Header(endpoints.PartChecksumHeader, "Hex SHA-256 the part must match"),
+			},
+			Body: openapi.BinaryBody(),
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The stored part", responses.UploadPartResponse{}),
+				badRequest,
+				openapi.Error(http.StatusNotFound, "Upload not found"),
+			},
+		}},
+		{handler: f(s.UploadEndpoints.HandleCompleteUpload), disabled: s.UploadEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/uploads/{id}/complete", ID: "completeUpload", Tag: "Uploads", Summary: "Complete an upload",
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusCreated, "The stored file", responses.FileResponse{}),
+				openapi.Error(http.StatusNotFound, "Upload not found"),
+				openapi.Error(http.StatusConflict, "Parts are missing"),
+				policyDenied,
+				storageFull,
+				scanRefused,
+				scanUnavailable,
+			},
+		}},
+		{handler: f(s.UploadEndpoints.HandleAbortUpload), disabled: s.UploadEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/uploads/{id}", ID: "abortUpload", Tag: "Uploads", Summary: "Abort an upload",
+			Responses: []openapi.Response{openapi.Empty(http.StatusNoContent, "The upload was discarded"), openapi.Error(http.StatusNotFound, "Upload not found")},
+		}},
+
+		// Search
+		{handler: f(s.SearchEndpoints.HandleSearch), disabled: s.SearchEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/search", ID: "searchDocuments", Tag: "Search", Summary: "Full-text search",
+			Params: []openapi.Param{
+				openapi.RequiredQuery("q", "string", "Search query"),
+				openapi.Query("limit", "integer", "Maximum number of hits; defaults to 20"),
+				openapi.Query("offset", "integer", "Number of hits to skip"),
+				openapi.Query("highlight", "boolean", "Include highlighted snippets; defaults to true"),
+			},
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The hits", responses.SearchResponse{}), badRequest},
+		}},
+		{handler: f(s.SearchEndpoints.HandleSearchStatus), disabled: s.SearchEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/search/status", ID: "getSearchStatus", Tag: "Search", Summary: "Search index status",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The index status", responses.SearchStatusResponse{})},
+		}},
+		{handler: f(s.SearchEndpoints.HandleRebuildIndex), disabled: s.SearchEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/search/rebuild", ID: "rebuildSearchIndex", Tag: "Search", Summary: "Rebuild the search index",
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusAccepted, "The rebuild started", responses.SearchStatusResponse{}),
+				openapi.Error(http.StatusConflict, "A rebuild is already running"),
+			},
+		}},
+
+		// Lifecycle rules
+		{handler: f(s.LifecycleEndpoints.HandleGetPolicy), disabled: s.LifecycleEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/lifecycle/policy", ID: "getLifecyclePolicy", Tag: "Lifecycle", Summary: "Get the lifecycle policy",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The policy", responses.LifecyclePolicyResponse{})},
+		}},
+		{handler: f(s.LifecycleEndpoints.HandleSetPolicy), disabled: s.LifecycleEndpoints == nil, Operation: openapi.Operation{
+			Method: "PUT", Path: "/api/v1/lifecycle/policy", ID: "setLifecyclePolicy", Tag: "Lifecycle", Summary: "Replace the lifecycle policy",
+			Body:      openapi.JSONBody(requests.LifecyclePolicyRequest{}),
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The new policy", responses.LifecyclePolicyResponse{}), badRequest},
+		}},
+		{handler: f(s.LifecycleEndpoints.HandleRun), disabled: s.LifecycleEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/lifecycle/run", ID: "runLifecycle", Tag: "Lifecycle", Summary: "Evaluate lifecycle rules now",
+			Params: []openapi.Param{openapi.Query("dry_run", "boolean", "Only report due actions; true unless false is given")},
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The run report", lifecycle.Report{}),
+				badRequest,
+				openapi.Error(http.StatusConflict, "A run is already in progress"),
+			},
+		}},
+		{handler: f(s.LifecycleEndpoints.HandleGetReport), disabled: s.LifecycleEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/lifecycle/report", ID: "getLifecycleReport", Tag: "Lifecycle", Summary: "Get the last lifecycle report",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The report", lifecycle.Report{}), openapi.Error(http.StatusNotFound, "No lifecycle run yet")},
+		}},
+
+		// Backups
+		{handler: f(s.BackupEndpoints.HandleListJobs), disabled: s.BackupEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/backups/jobs", ID: "listBackupJobs", Tag: "Backups", Summary: "List backup jobs",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The jobs", responses.BackupJobListResponse{})},
+		}},
+		{handler: f(s.BackupEndpoints.HandleRunJob), disabled: s.BackupEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/backups/jobs/{job}/run", ID: "runBackup", Tag: "Backups", Summary: "Start a backup",
+			Params: []openapi.Param{openapi.Query("type", "string", "full or incremental; the job's schedule decides when omitted")},
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusAccepted, "The backup started", responses.BackupRunResponse{}),
+				badRequest,
+				openapi.Error(http.StatusNotFound, "Job not found"),
+				openapi.Error(http.StatusConflict, "The job is already running"),
+			},
+		}},
+		{handler: f(s.BackupEndpoints.HandleListSnapshots), disabled: s.BackupEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/backups/jobs/{job}/snapshots", ID: "listBackupSnapshots", Tag: "Backups", Summary: "List snapshots",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The snapshots, oldest first", responses.BackupSnapshotListResponse{}), openapi.Error(http.StatusNotFound, "Job not found")},
+		}},
+		{handler: f(s.BackupEndpoints.HandleGetSnapshot), disabled: s.BackupEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/backups/jobs/{job}/snapshots/{id}", ID: "getBackupSnapshot", Tag: "Backups", Summary: "Get a snapshot",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The snapshot", backup.Snapshot{}), openapi.Error(http.StatusNotFound, "Snapshot not found")},
+		}},
+		{handler: f(s.BackupEndpoints.HandleDeleteSnapshot), disabled: s.BackupEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/backups/jobs/{job}/snapshots/{id}", ID: "deleteBackupSnapshot", Tag: "Backups", Summary: "Delete a snapshot",
+			Responses: []openapi.Response{openapi.Empty(http.StatusNoContent, "The snapshot was deleted"), openapi.Error(http.StatusNotFound, "Snapshot not found")},
+		}},
+		{handler: f(s.BackupEndpoints.HandleVerifySnapshot), disabled: s.BackupEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/backups/jobs/{job}/snapshots/{id}/verify", ID: "verifyBackupSnapshot", Tag: "Backups", Summary: "Verify a snapshot",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The verification report", backup.VerifyReport{}), openapi.Error(http.StatusNotFound, "Snapshot not found")},
+		}},
+		{handler: f(s.BackupEndpoints.HandleRestore), disabled: s.BackupEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/backups/jobs/{job}/restore", ID: "restoreBackup", Tag: "Backups", Summary: "Restore from a snapshot",
+			Body: openapi.JSONBody(requests.BackupRestoreRequest{}),
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The restore report", backup.RestoreReport{}),
+				badRequest,
+				openapi.Error(http.StatusNotFound, "Job or snapshot not found"),
+				openapi.Error(http.StatusConflict, "The job is busy"),
+			},
+		}},
+
+		// Snapshots
+		{handler: f(s.SnapshotEndpoints.HandleListSnapshots), disabled: s.SnapshotEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/snapshots", ID: "listSnapshots", Tag: "Snapshots", Summary: "List snapshots",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The snapshots, oldest first", responses.SnapshotListResponse{})},
+		}},
+		{handler: f(s.SnapshotEndpoints.HandleCreateSnapshot), disabled: s.SnapshotEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/snapshots", ID: "createSnapshot", Tag: "Snapshots", Summary: "Snapshot a namespace",
+			Description: "Records the key and content hash of every file under the namespace prefix. Nothing is copied until a recorded file is overwritten or deleted.",
+			Body:        openapi.JSONBody(requests.SnapshotCreateRequest{}),
+			Responses:   []openapi.Response{openapi.JSON(http.StatusCreated, "The snapshot, without its entries", snapshot.Snapshot{}), badRequest},
+		}},
+		{handler: f(s.SnapshotEndpoints.HandleGetSnapshot), disabled: s.SnapshotEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/snapshots/{id}", ID: "getSnapshot", Tag: "Snapshots", Summary: "Get a snapshot",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The snapshot and its entries", snapshot.Snapshot{}), snapshotNotFound},
+		}},
+		{handler: f(s.SnapshotEndpoints.HandleDeleteSnapshot), disabled: s.SnapshotEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/snapshots/{id}", ID: "deleteSnapshot", Tag: "Snapshots", Summary: "Delete a snapshot",
+			Responses: []openapi.Response{openapi.Empty(http.StatusNoContent, "The snapshot was deleted"), snapshotNotFound},
+		}},
+		{handler: f(s.SnapshotEndpoints.HandleDownloadFile), disabled: s.SnapshotEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/snapshots/{id}/files/{key...}", ID: "downloadSnapshotFile", Tag: "Snapshots", Summary: "Download a file as a snapshot recorded it",
+			Responses: []openapi.Response{
+				openapi.Binary(http.StatusOK, "The file content"),
+				openapi.Error(http.StatusNotFound, "Snapshot or file not found"),
+				openapi.Error(http.StatusGone, "The content changed on another node before it could be preserved"),
+			},
+		}},
+		{handler: f(s.SnapshotEndpoints.HandleDiff), disabled: s.SnapshotEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/snapshots/{id}/diff", ID: "diffSnapshot", Tag: "Snapshots", Summary: "Diff a snapshot",
+			Description: "Compares the snapshot with the snapshot `to`, or with the live files of its namespace when `to` is omitted.",
+			Params:      []openapi.Param{openapi.Query("to", "string", "Snapshot to compare with; the live files when omitted")},
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The changes", snapshot.Diff{}), snapshotNotFound},
+		}},
+		{handler: f(s.SnapshotEndpoints.HandleClone), disabled: s.SnapshotEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/snapshots/{id}/clone", ID: "cloneSnapshot", Tag: "Snapshots", Summary: "Clone a snapshot",
+			Description: "Writes the files of the snapshot under `prefix` in place of its namespace. Cloning onto the namespace itself with `overwrite` rolls it back.",
+			Body:        openapi.JSONBody(requests.SnapshotCloneRequest{}),
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The clone report", snapshot.CloneReport{}), badRequest, snapshotNotFound},
+		}},
+		{handler: f(s.SnapshotEndpoints.HandleBackup), disabled: s.SnapshotEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/snapshots/{id}/backup", ID: "backupSnapshot", Tag: "Snapshots", Summary: "Back up a snapshot",
+			Description: "Runs a backup job from the snapshot instead of the live files, so the backup is consistent as of the snapshot.",
+			Body:        openapi.JSONBody(requests.SnapshotBackupRequest{}),
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The backup snapshot, without its entries", backup.Snapshot{}),
+				badRequest,
+				openapi.Error(http.StatusNotFound, "Snapshot or job not found"),
+				openapi.Error(http.StatusConflict, "The job is busy"),
+			},
+		}},
+
+		// Geo-replication
+		{handler: f(s.GeoReplicationEndpoints.HandleStatus), disabled: s.GeoReplicationEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/replication/status", ID: "getReplicationStatus", Tag: "Replication", Summary: "Get geo-replication status",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "Progress and lag of every target and source", georeplication.Status{})},
+		}},
+		{handler: s.geoReplication, disabled: s.geoReplication == nil, Operation: openapi.Operation{
+			Method: "POST", Path: georeplication.Path, ID: "receiveReplicationBatch", Tag: "Replication", Summary: "Receive replicated changes",
+			Description: "Change batches from other clusters authenticate through their signature.",
+			Public:      true,
+			Params: []openapi.Param{
+				{Name: georeplication.HeaderTimestamp, In: "header", Required: true, Description: "Unix time the batch was signed at"},
+				{Name: georeplication.HeaderSignature, In: "header", Required: true, Description: "HMAC-SHA256 of the timestamp and body"},
+			},
+			Body: openapi.JSONBody(georeplication.Batch{}),
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "What was applied", georeplication.BatchResult{}),
+				badRequest,
+				openapi.Error(http.StatusUnauthorized, "Invalid signature"),
+				openapi.Error(http.StatusServiceUnavailable, "Metadata store is not primary"),
+			},
+		}},
+
+		// Public gateway
+		{handler: s.Gateway, disabled: s.Gateway == nil, Operation: openapi.Operation{
+			Method: "GET", Path: gateway.PathPrefix + "{key...}", ID: "downloadPublicFile", Tag: "Public", Summary: "Download a public file",
+			Description: "Anonymous read-only access to whitelisted files.",
+			Public:      true,
+			Responses: []openapi.Response{
+				openapi.Binary(http.StatusOK, "The file content"),
+				openapi.Error(http.StatusNotFound, "Not found"),
+				openapi.Error(http.StatusTooManyRequests, "Rate limit or download quota exceeded"),
+			},
+		}},
+
+		// Devices
+		{handler: f(s.DeviceEndpoints.HandleListDevices), disabled: s.DeviceEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/devices", ID: "listDevices", Tag: "Devices", Summary: "List IoT devices",
+			Description: "The devices registered with the device CA and the certificates issued to them. Their secrets are only returned when registered or reset.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The devices", responses.DeviceListResponse{})},
+		}},
+		{handler: f(s.DeviceEndpoints.HandleRegisterDevice), disabled: s.DeviceEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/devices", ID: "registerDevice", Tag: "Devices", Summary: "Register an IoT device",
+			Description: "The device is flashed with the secret returned and enrolls with EST for certificates named after its ID.",
+			Body:        openapi.JSONBody(requests.DeviceRequest{}),
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusCreated, "The device and its secret", responses.DeviceSecretResponse{}),
+				badRequest,
+				openapi.Error(http.StatusConflict, "A device with the ID is registered"),
+			},
+		}},
+		{handler: f(s.DeviceEndpoints.HandleGetDevice), disabled: s.DeviceEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/devices/{id}", ID: "getDevice", Tag: "Devices", Summary: "Get an IoT device",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The device", devices.Device{}), deviceNotFound},
+		}},
+		{handler: f(s.DeviceEndpoints.HandleRevokeDevice), disabled: s.DeviceEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/devices/{id}", ID: "revokeDevice", Tag: "Devices", Summary: "Revoke an IoT device",
+			Description: "Revokes every certificate of the device and refuses its enrollments until its secret is reset. The device stays registered.",
+			Responses:   []openapi.Response{openapi.Empty(http.StatusNoContent, "The device was revoked"), deviceNotFound},
+		}},
+		{handler: f(s.DeviceEndpoints.HandleResetSecret), disabled: s.DeviceEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/devices/{id}/secret", ID: "resetDeviceSecret", Tag: "Devices", Summary: "Reset the secret of an IoT device",
+			Description: "Gives the device a new secret, as when it is reflashed, and lets a revoked device enroll again. Its certificates stay as they are.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The device and its new secret", responses.DeviceSecretResponse{}), deviceNotFound},
+		}},
+		{handler: f(s.DeviceEndpoints.HandleRevokeCertificate), disabled: s.DeviceEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/devices/{id}/certificates/{serial}", ID: "revokeDeviceCertificate", Tag: "Devices", Summary: "Revoke a device certificate",
+			Description: "Revokes one certificate, by its hexadecimal serial number, while the device may still enroll.",
+			Responses: []openapi.Response{
+				openapi.Empty(http.StatusNoContent, "The certificate was revoked"),
+				openapi.Error(http.StatusNotFound, "Device or certificate not found"),
+			},
+		}},
+		{handler: f(s.DeviceEndpoints.HandleCACerts), disabled: s.DeviceEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: devices.ESTPath + "/cacerts", ID: "estCACerts", Tag: "Devices", Summary: "Get the device CA certificates",
+			Description: "EST (RFC 7030): the device CA as a base64 PKCS#7 certs-only message.",
+			Public:      true,
+			Responses:   []openapi.Response{estCerts},
+		}},
+		{handler: f(s.DeviceEndpoints.HandleSimpleEnroll), disabled: s.DeviceEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: devices.ESTPath + "/simpleenroll", ID: "estSimpleEnroll", Tag: "Devices", Summary: "Enroll a device",
+			Description: "EST (RFC 7030): issues a client certificate for a base64 PKCS#10 request whose common name is a registered device. The device proves its secret as the password of basic authentication, or with a challengePassword attribute holding the hex HMAC-SHA256 of its public key under the secret.",
+			Public:      true,
+			Body:        csrBody,
+			Responses:   []openapi.Response{estCerts, badRequest, enrollDenied},
+		}},
+		{handler: f(s.DeviceEndpoints.HandleSimpleReenroll), disabled: s.DeviceEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: devices.ESTPath + "/simplereenroll", ID: "estSimpleReenroll", Tag: "Devices", Summary: "Renew a device certificate",
+			Description: "EST (RFC 7030): like simpleenroll, for devices holding a certificate that is valid and not revoked.",
+			Public:      true,
+			Body:        csrBody,
+			Responses:   []openapi.Response{estCerts, badRequest, enrollDenied},
+		}},
+		{handler: f(s.DeviceEndpoints.HandleCRL), disabled: s.DeviceEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: devices.ESTPath + "/crl", ID: "getDeviceCRL", Tag: "Devices", Summary: "Get the device CRL",
+			Description: "The DER list of revoked device certificates that have not expired, signed by the device CA, for servers checking device certificates themselves.",
+			Public:      true,
+			Responses: []openapi.Response{{Status: http.StatusOK, Description: "The CRL", ContentType: "application/pkix-crl",
+				Schema: &openapi.Schema{Type: "string", Format: "binary"}}},
+		}},
+
+		// MQTT
+		{handler: f(s.MQTTACLEndpoints.HandleListRules), disabled: s.MQTTACLEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/mqtt/acl", ID: "listMQTTACLRules", Tag: "MQTT", Summary: "List MQTT topic ACL rules",
+			Description: "Rules of the configuration come first. Deny rules win over allow rules; once there is an allow rule for publishing or subscribing, only topics one matches may be published to or subscribed to.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The rules", responses.MQTTACLRuleListResponse{})},
+		}},
+		{handler: f(s.MQTTACLEndpoints.HandleAddRule), disabled: s.MQTTACLEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/mqtt/acl", ID: "addMQTTACLRule", Tag: "MQTT", Summary: "Add an MQTT topic ACL rule",
+			Description: "The rule applies to clients matching every criterion it sets. `{device}` and `{tenant}` in its topic stand for those of the client's device certificate. It applies to the next messages and subscriptions; existing subscriptions stay until clients reconnect.",
+			Body:        openapi.JSONBody(requests.MQTTACLRuleRequest{}),
+			Responses:   []openapi.Response{openapi.JSON(http.StatusCreated, "The rule", topicacl.Rule{}), badRequest},
+		}},
+		{handler: f(s.MQTTACLEndpoints.HandleRemoveRule), disabled: s.MQTTACLEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/mqtt/acl/{id}", ID: "removeMQTTACLRule", Tag: "MQTT", Summary: "Remove an MQTT topic ACL rule",
+			Responses: []openapi.Response{
+				openapi.Empty(http.StatusNoContent, "The rule was removed"),
+				openapi.Error(http.StatusNotFound, "Rule not found"),
+				openapi.Error(http.StatusConflict, "The rule comes from the configuration"),
+			},
+		}},
+
+		// LwM2M
+		{handler: f(s.LwM2MEndpoints.HandleListClients), disabled: s.LwM2MEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/lwm2m/clients", ID: "listLwM2MClients", Tag: "LwM2M", Summary: "List LwM2M devices",
+			Description: "Devices registered with the registration interface the CoAP API serves at /rd, whose registration has not expired.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The devices", responses.LwM2MClientListResponse{})},
+		}},
+		{handler: f(s.LwM2MEndpoints.HandleGetClient), disabled: s.LwM2MEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/lwm2m/clients/{endpoint}", ID: "getLwM2MClient", Tag: "LwM2M", Summary: "Get an LwM2M device",
+			Description: "The registration of a device by endpoint name, with its objects, the latest values of its observed paths and its last firmware update.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The registration", lwm2m.Registration{}), lwm2mNotFound},
+		}},
+		{handler: f(s.LwM2MEndpoints.HandleRead), disabled: s.LwM2MEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/lwm2m/clients/{endpoint}/resources/{path...}", ID: "readLwM2MResource", Tag: "LwM2M", Summary: "Read LwM2M resources",
+			Description: "Reads an object, object instance, resource or resource instance, such as 3/0 for the device information. Values of the core objects are typed by the object model.",
+			Responses:   append([]openapi.Response{openapi.JSON(http.StatusOK, "The values", responses.LwM2MValuesResponse{})}, lwm2mErrors...),
+		}},
+		{handler: f(s.LwM2MEndpoints.HandleWrite), disabled: s.LwM2MEndpoints == nil, Operation: openapi.Operation{
+			Method: "PUT", Path: "/api/v1/lwm2m/clients/{endpoint}/resources/{path...}", ID: "writeLwM2MResource", Tag: "LwM2M", Summary: "Write an LwM2M resource",
+			Body:      openapi.JSONBody(requests.LwM2MWriteRequest{}),
+			Responses: append([]openapi.Response{openapi.Empty(http.StatusNoContent, "The resource was written")}, lwm2mErrors...),
+		}},
+		{handler: f(s.LwM2MEndpoints.HandleExecute), disabled: s.LwM2MEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/lwm2m/clients/{endpoint}/resources/{path...}", ID: "executeLwM2MResource", Tag: "LwM2M", Summary: "Execute an LwM2M resource",
+			Description: "Executes a resource, such as 3/0/4 to reboot the device. The body is optional.",
+			Body:        openapi.JSONBody(requests.LwM2MExecuteRequest{}),
+			Responses:   append([]openapi.Response{openapi.Empty(http.StatusNoContent, "The resource was executed")}, lwm2mErrors...),
+		}},
+		{handler: f(s.LwM2MEndpoints.HandleObserve), disabled: s.LwM2MEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/lwm2m/clients/{endpoint}/observations", ID: "observeLwM2MResource", Tag: "LwM2M", Summary: "Observe LwM2M resources",
+			Description: "Observes a path until the device registers again. The values the device notifies replace those in the observations of its registration.",
+			Body:        openapi.JSONBody(requests.LwM2MObserveRequest{}),
+			Responses:   append([]openapi.Response{openapi.JSON(http.StatusCreated, "The current values", responses.LwM2MValuesResponse{})}, lwm2mErrors...),
+		}},
+		{handler: f(s.LwM2MEndpoints.HandleCancelObservation), disabled: s.LwM2MEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/lwm2m/clients/{endpoint}/observations/{path...}", ID: "cancelLwM2MObservation", Tag: "LwM2M", Summary: "Stop observing LwM2M resources",
+			Responses: []openapi.Response{
+				openapi.Empty(http.StatusNoContent, "The observation was cancelled"),
+				openapi.Error(http.StatusNotFound, "Device not registered or path not observed"),
+			},
+		}},
+		{handler: f(s.LwM2MEndpoints.HandleUpdateFirmware), disabled: s.LwM2MEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/lwm2m/clients/{endpoint}/firmware", ID: "updateLwM2MFirmware", Tag: "LwM2M", Summary: "Update the firmware of an LwM2M device",
+			Description: "Writes the package URI to the Firmware Update object (5/0/1) and observes its State and Update Result. Once the device has downloaded the package the update is executed; the registration's firmware shows its progress.",
+			Body:        openapi.JSONBody(requests.LwM2MFirmwareRequest{}),
+			Responses:   append([]openapi.Response{openapi.JSON(http.StatusAccepted, "The update was started", lwm2m.FirmwareUpdate{})}, lwm2mErrors...),
+		}},
+		{handler: f(s.LwM2MEndpoints.HandleListObjects), disabled: s.LwM2MEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/lwm2m/objects", ID: "listLwM2MObjects", Tag: "LwM2M", Summary: "List the LwM2M object model",
+			Description: "The objects whose resources values are typed; values of other objects are read as text or bytes.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The objects", responses.LwM2MObjectListResponse{})},
+		}},
+
+		// Firmware
+		{handler: f(s.FirmwareEndpoints.HandleListImages), disabled: s.FirmwareEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: firmware.HTTPPath + "/images", ID: "listFirmwareImages", Tag: "Firmware", Summary: "List firmware images",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The images", responses.FirmwareImageListResponse{})},
+		}},
+		{handler: f(s.FirmwareEndpoints.HandleUploadImage), disabled: s.FirmwareEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: firmware.HTTPPath + "/images", ID: "uploadFirmwareImage", Tag: "Firmware", Summary: "Upload a firmware image",
+			Description: "Stores the image, up to 64 MiB, as an object of the node and signs its name, version, size and SHA-256 with the node's Ed25519 firmware key.",
+			Params: []openapi.Param{
+				openapi.RequiredQuery("name", "string", "The product the image is for"),
+				openapi.RequiredQuery("version", "string", "The version of the image"),
+				openapi.Query("comment", "string", "What the image changes"),
+			},
+			Body: openapi.BinaryBody(),
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusCreated, "The signed image", firmware.Image{}),
+				badRequest,
+				openapi.Error(http.StatusConflict, "An image with the name and version exists"),
+				openapi.Error(http.StatusRequestEntityTooLarge, "The image is larger than 64 MiB"),
+			},
+		}},
+		{handler: f(s.FirmwareEndpoints.HandleGetImage), disabled: s.FirmwareEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: firmware.HTTPPath + "/images/{id}", ID: "getFirmwareImage", Tag: "Firmware", Summary: "Get a firmware image",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The image", firmware.Image{}), imageNotFound},
+		}},
+		{handler: f(s.FirmwareEndpoints.HandleDeleteImage), disabled: s.FirmwareEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: firmware.HTTPPath + "/images/{id}", ID: "deleteFirmwareImage", Tag: "Firmware", Summary: "Delete a firmware image",
+			Responses: []openapi.Response{
+				openapi.Empty(http.StatusNoContent, "The image was deleted"),
+				imageNotFound,
+				openapi.Error(http.StatusConflict, "A rollout that is not cancelled releases the image"),
+			},
+		}},
+		{handler: f(s.FirmwareEndpoints.HandleImageContent), disabled: s.FirmwareEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: firmware.HTTPPath + "/images/{id}/content", ID: "downloadFirmwareImage", Tag: "Firmware", Summary: "Download a firmware image",
+			Description: "The " + endpoints.FirmwareSHA256Header + ", " + endpoints.FirmwareSignatureHeader + " and " + endpoints.FirmwareKeyIDHeader + " headers carry the digest and signature devices verify the image with.",
+			Responses:   []openapi.Response{openapi.Binary(http.StatusOK, "The image"), imageNotFound},
+		}},
+		{handler: f(s.FirmwareEndpoints.HandleSigningKey), disabled: s.FirmwareEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: firmware.HTTPPath + "/key", ID: "getFirmwareSigningKey", Tag: "Firmware", Summary: "Get the firmware signing key",
+			Description: "The public key devices are provisioned with to verify image signatures.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The public key", firmware.SigningKey{})},
+		}},
+		{handler: f(s.FirmwareEndpoints.HandleListRollouts), disabled: s.FirmwareEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: firmware.HTTPPath + "/rollouts", ID: "listFirmwareRollouts", Tag: "Firmware", Summary: "List firmware rollouts",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The rollouts and how many devices are in each state", responses.FirmwareRolloutListResponse{})},
+		}},
+		{handler: f(s.FirmwareEndpoints.HandleCreateRollout), disabled: s.FirmwareEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: firmware.HTTPPath + "/rollouts", ID: "createFirmwareRollout", Tag: "Firmware", Summary: "Roll out a firmware image",
+			Description: "Releases the image to the first ring at once. Rings are cumulative and release to a percentage of all devices, to the devices of tenants or to devices by ID.",
+			Body:        openapi.JSONBody(requests.FirmwareRolloutRequest{}),
+			Responses:   []openapi.Response{openapi.JSON(http.StatusCreated, "The rollout", firmware.Rollout{}), badRequest, imageNotFound},
+		}},
+		{handler: f(s.FirmwareEndpoints.HandleGetRollout), disabled: s.FirmwareEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: firmware.HTTPPath + "/rollouts/{id}", ID: "getFirmwareRollout", Tag: "Firmware", Summary: "Get a firmware rollout",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The rollout and the status of each device", responses.FirmwareRolloutResponse{}), rolloutNotFound},
+		}},
+		{handler: f(s.FirmwareEndpoints.HandleCancelRollout), disabled: s.FirmwareEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: firmware.HTTPPath + "/rollouts/{id}", ID: "cancelFirmwareRollout", Tag: "Firmware", Summary: "Cancel a firmware rollout",
+			Description: "The rollout stops offering its image for good and its image may be deleted.",
+			Responses:   []openapi.Response{openapi.Empty(http.StatusNoContent, "The rollout was cancelled"), rolloutNotFound},
+		}},
+		{handler: f(s.FirmwareEndpoints.HandleAdvanceRollout), disabled: s.FirmwareEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: firmware.HTTPPath + "/rollouts/{id}/advance", ID: "advanceFirmwareRollout", Tag: "Firmware", Summary: "Release the next ring of a rollout",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The rollout", firmware.Rollout{}), openapi.Error(http.StatusBadRequest, "Every ring is released or the rollout is cancelled"), rolloutNotFound},
+		}},
+		{handler: f(s.FirmwareEndpoints.HandlePauseRollout), disabled: s.FirmwareEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: firmware.HTTPPath + "/rollouts/{id}/pause", ID: "pauseFirmwareRollout", Tag: "Firmware", Summary: "Pause a firmware rollout",
+			Description: "Rollouts also pause by themselves once their failure threshold is reached.",
+			Body:        openapi.JSONBody(requests.FirmwarePauseRequest{}),
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The rollout", firmware.Rollout{}), badRequest, rolloutNotFound},
+		}},
+		{handler: f(s.FirmwareEndpoints.HandleResumeRollout), disabled: s.FirmwareEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: firmware.HTTPPath + "/rollouts/{id}/resume", ID: "resumeFirmwareRollout", Tag: "Firmware", Summary: "Resume a firmware rollout",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The rollout", firmware.Rollout{}), badRequest, rolloutNotFound},
+		}},
+		{handler: f(s.FirmwareEndpoints.HandleGetDeviceStatus), disabled: s.FirmwareEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: firmware.HTTPPath + "/devices/{device}", ID: "getFirmwareDeviceStatus", Tag: "Firmware", Summary: "Get the install status of a device",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The status", firmware.DeviceStatus{}), openapi.Error(http.StatusNotFound, "No update was offered to the device")},
+		}},
+		{handler: f(s.FirmwareEndpoints.HandleCheckUpdate), disabled: s.FirmwareEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: firmware.HTTPPath + "/devices/{device}/manifest", ID: "checkFirmwareUpdate", Tag: "Firmware", Summary: "Check for a firmware update",
+			Description: "The update the newest active rollout releasing to the device offers, with the signed image and where to download it over HTTPS, CoAP and MQTT.",
+			Params:      []openapi.Param{openapi.Query("version", "string", "The version the device runs")},
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The update", firmware.Manifest{}),
+				openapi.Empty(http.StatusNoContent, "No update is offered"),
+				badRequest,
+				firmwareDenied,
+			},
+		}},
+		{handler: f(s.FirmwareEndpoints.HandleReportStatus), disabled: s.FirmwareEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: firmware.HTTPPath + "/devices/{device}/status", ID: "reportFirmwareStatus", Tag: "Firmware", Summary: "Report the install status of a device",
+			Description: "Failures past the failure threshold of the rollout pause it.",
+			Body:        openapi.JSONBody(requests.FirmwareStatusRequest{}),
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The status", firmware.DeviceStatus{}),
+				badRequest,
+				firmwareDenied,
+				openapi.Error(http.StatusNotFound, "No rollout of the image releases to the device"),
+			},
+		}},
+
+		// Container registry
+		{handler: s.Registry, disabled: s.Registry == nil, Operation: openapi.Operation{
+			Method: "GET", Path: registry.PathPrefix + "{path...}", ID: "registryGet", Tag: "Registry", Summary: "Pull from the container registry",
+			Description: "The read endpoints of the OCI distribution API: the API version check, manifests and blobs by digest or tag, tag lists, the catalog and the status of blob uploads. HEAD is answered too. Clients may send the API token as the password of basic authentication.",
+			Responses: []openapi.Response{
+				openapi.Binary(http.StatusOK, "The manifest, blob or listing"),
+				openapi.Empty(http.StatusNoContent, "The status of a blob upload, in its Range header"),
+				registryError(http.StatusUnauthorized, "The token is missing or invalid"),
+				registryError(http.StatusNotFound, "The repository, manifest, blob or upload is unknown"),
+			},
+		}},
+		{handler: s.Registry, disabled: s.Registry == nil, Operation: openapi.Operation{
+			Method: "POST", Path: registry.PathPrefix + "{path...}", ID: "registryStartUpload", Tag: "Registry", Summary: "Start a blob upload",
+			Description: "Starts a chunked blob upload on <name>/blobs/uploads/, stores a blob sent with its digest in one request, or mounts a stored blob with the mount parameter.",
+			Params: []openapi.Param{
+				openapi.Query("digest", "string", "Digest of a blob sent in the request body"),
+				openapi.Query("mount", "string", "Digest of a stored blob to add to the repository"),
+			},
+			Body: openapi.BinaryBody(),
+			Responses: []openapi.Response{
+				openapi.Empty(http.StatusCreated, "The blob is stored"),
+				openapi.Empty(http.StatusAccepted, "The upload started; its URL is in the Location header"),
+				registryError(http.StatusBadRequest, "The digest or repository name is invalid, or the content does not match the digest"),
+				registryError(http.StatusUnauthorized, "The token is missing or invalid"),
+			},
+		}},
+		{handler: s.Registry, disabled: s.Registry == nil, Operation: openapi.Operation{
+			Method: "PATCH", Path: registry.PathPrefix + "{path...}", ID: "registryUploadChunk", Tag: "Registry", Summary: "Upload a blob chunk",
+			Description: "Appends a chunk to a blob upload. Chunks are staged as resumable uploads; a Content-Range must start where the upload ends.",
+			Params:      []openapi.Param{openapi.Header("Content-Range", "Range of the blob the chunk holds")},
+			Body:        openapi.BinaryBody(),
+			Responses: []openapi.Response{
+				openapi.Empty(http.StatusAccepted, "The chunk is stored; the Range header tells how much the upload has"),
+				registryError(http.StatusNotFound, "The upload is unknown"),
+				registryError(http.StatusRequestedRangeNotSatisfiable, "The chunk does not start where the upload ends"),
+			},
+		}},
+		{handler: s.Registry, disabled: s.Registry == nil, Operation: openapi.Operation{
+			Method: "PUT", Path: registry.PathPrefix + "{path...}", ID: "registryPut", Tag: "Registry", Summary: "Push a manifest or finish a blob upload",
+			Description: "Stores a manifest by tag or digest once the blobs it references are stored, or finishes a blob upload, appending the body, once the content has the digest parameter.",
+			Params:      []openapi.Param{openapi.Query("digest", "string", "Digest of the uploaded blob")},
+			Body:        openapi.BinaryBody(),
+			Responses: []openapi.Response{
+				openapi.Empty(http.StatusCreated, "The manifest or blob is stored"),
+				registryError(http.StatusBadRequest, "The manifest or digest is invalid, or the content does not match the digest"),
+				registryError(http.StatusNotFound, "A referenced blob or the upload is unknown"),
+				registryError(http.StatusRequestEntityTooLarge, "The manifest or blob is over the size limit"),
+			},
+		}},
+		{handler: s.Registry, disabled: s.Registry == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: registry.PathPrefix + "{path...}", ID: "registryDelete", Tag: "Registry", Summary: "Delete a tag, manifest or upload",
+			Description: "Deletes a tag, a manifest and the tags pointing to it, or cancels a blob upload. Blobs are shared by repositories and are not deleted.",
+			Responses: []openapi.Response{
+				openapi.Empty(http.StatusAccepted, "The tag or manifest is deleted"),
+				openapi.Empty(http.StatusNoContent, "The upload is cancelled"),
+				registryError(http.StatusNotFound, "The manifest, tag or upload is unknown"),
+				registryError(http.StatusMethodNotAllowed, "Blobs are not deleted"),
+			},
+		}},
+
+		// Encryption keys
+		{handler: f(s.KeyEndpoints.HandleGetStatus), disabled: s.KeyEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/keys", ID: "getKeyRotation", Tag: "Keys", Summary: "Get the encryption key generation",
+			Description: "The generation new files are encrypted with, and the progress of re-encrypting stored files after the last rotation.",
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The key generation and re-encryption progress", fileserver.KeyRotationStatus{}),
+				openapi.Error(http.StatusConflict, "The node encrypts with a fixed key"),
+			},
+		}},
+		{handler: f(s.KeyEndpoints.HandleRotate), disabled: s.KeyEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/keys/rotate", ID: "rotateKey", Tag: "Keys", Summary: "Rotate the encryption key",
+			Description: "New writes use the next key generation at once. Stored files are re-encrypted in the background at a bounded rate, resuming after restarts; files of every generation stay readable meanwhile.",
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusAccepted, "The new key generation", fileserver.KeyRotationStatus{}),
+				openapi.Error(http.StatusConflict, "The node encrypts with a fixed key"),
+			},
+		}},
+
+		// Jobs
+		{handler: f(s.JobEndpoints.HandleListJobs), disabled: s.JobEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/jobs", ID: "listJobs", Tag: "Jobs", Summary: "List the periodic jobs of the node",
+			Description: "Reports, key rotation, edge garbage collection, lifecycle runs and backups, each with its schedule, next run and last run.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The jobs, by name", responses.JobListResponse{})},
+		}},
+		{handler: f(s.JobEndpoints.HandleGetJob), disabled: s.JobEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/jobs/{name}", ID: "getJob", Tag: "Jobs", Summary: "Get a job and its recent runs",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The job and its runs, newest first", responses.JobResponse{}), jobNotFound},
+		}},
+		{handler: f(s.JobEndpoints.HandleUpdateJob), disabled: s.JobEndpoints == nil, Operation: openapi.Operation{
+			Method: "PATCH", Path: "/api/v1/jobs/{name}", ID: "updateJob", Tag: "Jobs", Summary: "Change the schedule of a job or pause it",
+			Description: "Changed schedules and paused jobs persist across restarts. An empty schedule restores the default of the job.",
+			Body:        openapi.JSONBody(requests.JobRequest{}),
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The job", scheduler.JobStatus{}), badRequest, jobNotFound},
+		}},
+		{handler: f(s.JobEndpoints.HandleRunJob), disabled: s.JobEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/jobs/{name}/run", ID: "runJob", Tag: "Jobs", Summary: "Run a job now",
+			Description: "The job runs in the background, paused or not; its outcome is recorded in the runs of the job.",
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusAccepted, "The run started", scheduler.Run{}),
+				jobNotFound,
+				openapi.Error(http.StatusConflict, "The job is already running"),
+			},
+		}},
+
+		// Queue
+		{handler: f(s.QueueEndpoints.HandleGetStats), disabled: s.QueueEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/queue", ID: "getQueueStats", Tag: "Queue", Summary: "Count the queued background jobs",
+			Description: "Pending, running and dead jobs, the jobs that are not dead by kind, and the jobs completed and attempts failed since the node started.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The counts", queue.Stats{})},
+		}},
+		{handler: f(s.QueueEndpoints.HandleListJobs), disabled: s.QueueEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/queue/jobs", ID: "listQueuedJobs", Tag: "Queue", Summary: "List queued background jobs",
+			Description: "Replication, re-encryption, thumbnail and webhook jobs. Running jobs come first, then pending ones in the order they run, then dead letters, newest first.",
+			Params: []openapi.Param{
+				openapi.Query("state", "string", "pending, running or dead; dead lists the dead letters"),
+				openapi.Query("kind", "string", "Only jobs of this kind, e.g. replicate or webhook"),
+			},
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The jobs", responses.QueueJobListResponse{}), badRequest},
+		}},
+		{handler: f(s.QueueEndpoints.HandleGetJob), disabled: s.QueueEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/queue/jobs/{id}", ID: "getQueuedJob", Tag: "Queue", Summary: "Get a queued background job",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The job", queue.Job{}), jobNotFound},
+		}},
+		{handler: f(s.QueueEndpoints.HandleDeleteJob), disabled: s.QueueEndpoints == nil, Operation: openapi.Operation{
+			Method: "DELETE", Path: "/api/v1/queue/jobs/{id}", ID: "deleteQueuedJob", Tag: "Queue", Summary: "Drop a pending or dead job",
+			Responses: []openapi.Response{
+				openapi.Empty(http.StatusNoContent, "The job was dropped"),
+				jobNotFound,
+				openapi.Error(http.StatusConflict, "The job is running"),
+			},
+		}},
+		{handler: f(s.QueueEndpoints.HandleRequeueJob), disabled: s.QueueEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/queue/jobs/{id}/requeue", ID: "requeueJob", Tag: "Queue", Summary: "Run a dead job again",
+			Description: "The job gets its attempts back and runs as soon as a worker is free.",
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The requeued job", queue.Job{}),
+				jobNotFound,
+				openapi.Error(http.StatusConflict, "The job is not dead"),
+			},
+		}},
+		{handler: f(s.QueueEndpoints.HandleRequeueDead), disabled: s.QueueEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/queue/dead/requeue", ID: "requeueDeadJobs", Tag: "Queue", Summary: "Run the dead jobs again",
+			Params:    []openapi.Param{openapi.Query("kind", "string", "Only dead jobs of this kind")},
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "How many jobs were requeued", responses.QueueRequeueResponse{})},
+		}},
+
+		// Policy
+		{handler: f(s.PolicyEndpoints.HandleGetPolicy), disabled: s.PolicyEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/policy", ID: "getPolicy", Tag: "Policy", Summary: "Get the policy rules",
+			Description: "The CEL rules evaluated when files are stored, replicated to a peer or shared.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The rules", responses.PolicyResponse{})},
+		}},
+		{handler: f(s.PolicyEndpoints.HandleListDecisions), disabled: s.PolicyEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/policy/decisions", ID: "listPolicyDecisions", Tag: "Policy", Summary: "List recent policy decisions",
+			Params: []openapi.Param{
+				openapi.Query("limit", "integer", "Maximum decisions returned (default 100, 0 for all kept)"),
+				openapi.Query("denied", "boolean", "Only return denials"),
+			},
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The decisions, newest first", responses.PolicyDecisionListResponse{}), badRequest},
+		}},
+
+		// Hooks
+		{handler: f(s.HookEndpoints.HandleListHooks), disabled: s.HookEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/hooks", ID: "listHooks", Tag: "Hooks", Summary: "List hooks",
+			Description: "The WebAssembly hooks run before files are stored, read and deleted and after they are stored, in the order they run, with their calls, rejections, errors, timeouts and total run time.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The hooks", responses.HookListResponse{})},
+		}},
+
+		// Exports
+		{handler: f(s.ExportEndpoints.HandleListExports), disabled: s.ExportEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/exports", ID: "listExports", Tag: "Exports", Summary: "List exports",
+			Description: "The exports of the node's exports file, with where they write and the outcome of their last run. Scheduled exports also appear as jobs named export-<name>.",
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The exports", responses.ExportListResponse{})},
+		}},
+		{handler: f(s.ExportEndpoints.HandleRunExport), disabled: s.ExportEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/exports/{name}/run", ID: "runExport", Tag: "Exports", Summary: "Run an export now",
+			Description: "Writes the dataset of the export as CSV or Parquet under `<prefix><name>/<time>.<format>`, to the export's target or else to the cluster.",
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "Where the file was written", export.Run{}),
+				openapi.Error(http.StatusNotFound, "Export not found"),
+				openapi.Error(http.StatusConflict, "The node does not keep the dataset, such as accesses without an access log"),
+				openapi.Error(http.StatusInternalServerError, "The file could not be written"),
+			},
+		}},
+
+		// Query
+		{handler: f(s.QueryEndpoints.HandleQuery), disabled: s.QueryEndpoints == nil, Operation: openapi.Operation{
+			Method: "POST", Path: "/api/v1/query", ID: "runQuery", Tag: "Query", Summary: "Run a SQL query",
+			Description: "Runs a read-only SELECT over the metadata index, such as SELECT tenant, SUM(size) FROM objects GROUP BY tenant. Statements other than SELECT, unknown tables and columns and syntax errors are answered 400.",
+			Body:        openapi.JSONBody(requests.QueryRequest{}),
+			Responses:   []openapi.Response{openapi.JSON(http.StatusOK, "The rows", responses.QueryResponse{}), badRequest},
+		}},
+		{handler: f(s.QueryEndpoints.HandleListTables), disabled: s.QueryEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/query/tables", ID: "listQueryTables", Tag: "Query", Summary: "List the SQL tables",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The tables and their columns", responses.QueryTableListResponse{})},
+		}},
+
+		// Storage
+		{handler: f(s.FsckEndpoints.HandleCheck), disabled: s.FsckEndpoints == nil, Operation: openapi.Operation{
+			Method: "GET", Path: "/api/v1/storage/fsck", ID: "checkStorage", Tag: "Storage", Summary: "Check the local store",
+			Description: "Reads every object of the node's store and verifies its invariants: every chunk a manifest references is stored and every stored chunk is referenced, chunks and objects sit at the paths their hashes name, and the chunks of each manifest cover its object exactly. The store lock is held while checking, so writes wait for it.",
+			Responses: []openapi.Response{
+				openapi.JSON(http.StatusOK, "The objects checked and the invariants found broken", storage.CheckReport{}),
+				openapi.Error(http.StatusInternalServerError, "The store could not be read"),
+			},
+		}},
+
+		// System
+		{handler: f(s.SystemEndpoints.HandleHealth), Operation: openapi.Operation{
+			Method: "GET", Path: "/health", ID: "healthCheck", Tag: "System", Summary: "Health check", Public: true,
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "Health status", responses.HealthResponse{})},
+		}},
+		{handler: f(s.SystemEndpoints.HandleMetrics), Operation: openapi.Operation{
+			Method: "GET", Path: "/metrics", ID: "getMetrics", Tag: "System", Summary: "Get system metrics",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "System metrics", responses.MetricsResponse{})},
+		}},
+		{handler: f(s.SystemEndpoints.HandleSystemInfo), Operation: openapi.Operation{
+			Method: "GET", Path: "/system", ID: "getSystemInfo", Tag: "System", Summary: "Get system information",
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "System information", responses.SystemInfoResponse{})},
+		}},
+		{handler: f(s.SystemEndpoints.HandleWebhook), Operation: openapi.Operation{
+			Method: "POST", Path: "/webhook", ID: "webhook", Tag: "System", Summary: "Receive a webhook event",
+			Body:      openapi.JSONBody(requests.WebhookRequest{}),
+			Responses: []openapi.Response{openapi.Empty(http.StatusOK, "The event was received"), badRequest},
+		}},
+		{handler: f(s.SystemEndpoints.HandleRoot), Operation: openapi.Operation{
+			Method: "GET", Path: "/api", ID: "getApiInfo", Tag: "System", Summary: "Get API information", Public: true,
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "API name, version and entry points", map[string]interface{}{})},
+		}},
+		{handler: f(s.SystemEndpoints.HandleDocs), Operation: openapi.Operation{
+			Method: "GET", Path: "/docs", ID: "getDocs", Tag: "System", Summary: "Swagger UI", Public: true,
+			Responses: []openapi.Response{{Status: http.StatusOK, Description: "The Swagger UI page", ContentType: "text/html", Schema: &openapi.Schema{Type: "string"}}},
+		}},
+		{handler: f(s.SystemEndpoints.HandleSwaggerJSON), Operation: openapi.Operation{
+			Method: "GET", Path: "/swagger.json", ID: "getOpenAPISpec", Tag: "System", Summary: "This OpenAPI document", Public: true,
+			Responses: []openapi.Response{openapi.JSON(http.StatusOK, "The OpenAPI document", map[string]interface{}{})},
+		}},
+	}
+}
+
+// OpenAPI describes every endpoint of the REST API, including those of
+// optional features that are not configured
+func OpenAPI() (*openapi.Document, error) {
+	s := &Server{}
+	ops := make([]openapi.Operation, 0, 64)
+	for _, r := range s.routes() {
+		ops = append(ops, r.Operation)
+	}
+	return openapi.Build(openapi.Info{
+		Title:       "PeerVault REST API",
+		Description: "PeerVault is a distributed, encrypted file storage system with peer-to-peer replication.",
+		Version:     "1.0.0",
+	}, []openapi.Server{
+		{URL: "http://localhost:8081", Description: "Local development server"},
+	}, []openapi.Tag{
+		{Name: "Files", Description: "Store, fetch and describe files"},
+		{Name: "Directories", Description: "Folders of the key space and recursive operations on them"},
+		{Name: "Conflicts", Description: "Versions of files written concurrently on different nodes"},
+		{Name: "Documents", Description: "Shared documents kept on every node and merged without coordination"},
+		{Name: "JSON", Description: "JSON documents stored as files, with patches, ETag preconditions and schemas"},
+		{Name: "Logs", Description: "Append-only logs of records, read by offset or time and followed as they grow"},
+		{Name: "Analytics", Description: "Access rollups per key, tenant and peer, and scheduled reports on them"},
+		{Name: "Alerts", Description: "Alert rules over the node's metrics, notification channels and silences"},
+		{Name: "Grafana", Description: "JSON datasource for Grafana over node metrics and access rollups"},
+		{Name: "Locks", Description: "Retention locks and legal holds"},
+		{Name: "Leases", Description: "Distributed locks and leases with fencing tokens"},
+		{Name: "Peers", Description: "Peers of the node"},
+		{Name: "Shares", Description: "Signed share links"},
+		{Name: "Uploads", Description: "Resumable uploads of large files in parts"},
+		{Name: "Search", Description: "Full-text search of document content"},
+		{Name: "Lifecycle", Description: "Lifecycle rules"},
+		{Name: "Backups", Description: "Backup jobs, snapshots and restores"},
+		{Name: "Snapshots", Description: "Point-in-time, copy-on-write snapshots of namespaces"},
+		{Name: "Replication", Description: "Geo-replication between clusters"},
+		{Name: "Public", Description: "Anonymous access through the public gateway"},
+		{Name: "Registry", Description: "OCI distribution API serving container images from the store"},
+		{Name: "Devices", Description: "IoT devices and the certificates they enroll for with EST"},
+		{Name: "MQTT", Description: "Topics MQTT clients may publish to and subscribe to"},
+		{Name: "LwM2M", Description: "Management of the devices registered over LwM2M"},
+		{Name: "Firmware", Description: "Firmware images and their staged rollouts to IoT devices"},
+		{Name: "Keys", Description: "Rotation of the keys files are encrypted with at rest"},
+		{Name: "Jobs", Description: "Periodic work of the node on cron schedules, and its runs"},
+		{Name: "Queue", Description: "Background jobs retried until they succeed, and the dead letters of those that did not"},
+		{Name: "Policy", Description: "Rules evaluated on storing, replicating and sharing files, and their decisions"},
+		{Name: "Hooks", Description: "WebAssembly hooks run on the lifecycle of requests, and their counters"},
+		{Name: "Exports", Description: "CSV and Parquet exports of metadata, accesses and rollups for BI tools"},
+		{Name: "Query", Description: "Read-only SQL queries over the metadata index"},
+		{Name: "Storage", Description: "Consistency checks of the node's local store"},
+		{Name: "System", Description: "Health, metrics and documentation"},
+	}, ops)
+}
+
+// registryError is an error response of the container registry, which
+// follows the distribution spec rather than the shared error model
+func registryError(status int, description string) openapi.Response {
+	return openapi.Response{Status: status, Description: description, ContentType: "application/json", Schema: &openapi.Schema{
+		Description: `Errors as {"errors": [{"code", "message"}]}`,
+	}}
+}
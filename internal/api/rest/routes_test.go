@@ -0,0 +1,47 @@
+package rest
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// The committed specification must be regenerated with go generate
+// whenever the routes change
+func TestOpenAPIMatchesCommittedSpec(t *testing.T) {
+	doc, err := OpenAPI()
+	if err != nil {
+		t.Fatalf("Failed to build the OpenAPI document: %v", err)
+	}
+	want, err := doc.YAML()
+	if err != nil {
+		t.Fatalf("Failed to encode the OpenAPI document: %v", err)
+	}
+	got, err := os.ReadFile("../../../docs/api/peervault-rest-api.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read the committed spec: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("docs/api/peervault-rest-api.yaml is out of date; run go generate ./internal/api/rest")
+	}
+}
+
+func TestRoutesAreDocumented(t *testing.T) {
+	s := &Server{}
+	for _, r := range s.routes() {
+		if r.Summary == "" || r.Tag == "" {
+			t.Errorf("%s %s needs a summary and a tag", r.Method, r.Path)
+		}
+		if !strings.HasPrefix(r.Path, "/") {
+			t.Errorf("%s %s is not an absolute path", r.Method, r.Path)
+		}
+		success := false
+		for _, resp := range r.Responses {
+			success = success || resp.Status >= 200 && resp.Status < 300
+		}
+		if !success {
+			t.Errorf("%s %s documents no success response", r.Method, r.Path)
+		}
+	}
+}
@@ -2,14 +2,46 @@ package rest
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
 	"log/slog"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/Skpow1234/Peervault/internal/api/apierror"
+	"github.com/Skpow1234/Peervault/internal/api/httpguard"
+	"github.com/Skpow1234/Peervault/internal/api/nfs"
 	"github.com/Skpow1234/Peervault/internal/api/rest/endpoints"
+	"github.com/Skpow1234/Peervault/internal/api/rest/gateway"
 	"github.com/Skpow1234/Peervault/internal/api/rest/implementations"
 	"github.com/Skpow1234/Peervault/internal/api/rest/ratelimit"
+	"github.com/Skpow1234/Peervault/internal/api/rest/registry"
+	"github.com/Skpow1234/Peervault/internal/api/rest/services"
 	"github.com/Skpow1234/Peervault/internal/api/rest/versioning"
+	"github.com/Skpow1234/Peervault/internal/api/sftp"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/backup"
+	"github.com/Skpow1234/Peervault/internal/consensus"
+	"github.com/Skpow1234/Peervault/internal/devices"
+	"github.com/Skpow1234/Peervault/internal/georeplication"
+	"github.com/Skpow1234/Peervault/internal/jsondoc"
+	"github.com/Skpow1234/Peervault/internal/lifecycle"
+	"github.com/Skpow1234/Peervault/internal/media"
+	"github.com/Skpow1234/Peervault/internal/policy"
+	"github.com/Skpow1234/Peervault/internal/queue"
+	"github.com/Skpow1234/Peervault/internal/requestid"
+	"github.com/Skpow1234/Peervault/internal/retention"
+	"github.com/Skpow1234/Peervault/internal/scheduler"
+	"github.com/Skpow1234/Peervault/internal/search"
+	"github.com/Skpow1234/Peervault/internal/sharing"
+	"github.com/Skpow1234/Peervault/internal/snapshot"
+	"github.com/Skpow1234/Peervault/internal/streamlog"
+	"github.com/Skpow1234/Peervault/internal/uploads"
+	"github.com/Skpow1234/Peervault/pkg/plugin"
 )
 
 type Server struct {
@@ -20,6 +52,96 @@ type Server struct {
 	FileEndpoints   *endpoints.FileEndpoints
 	PeerEndpoints   *endpoints.PeerEndpoints
 	SystemEndpoints *endpoints.SystemEndpoints
+	ShareEndpoints  *endpoints.ShareEndpoints
+	// UploadEndpoints is nil when the upload staging directory is unusable
+	UploadEndpoints *endpoints.UploadEndpoints
+	// SearchEndpoints is nil when search is disabled
+	SearchEndpoints *endpoints.SearchEndpoints
+	searchIndex     *search.Index
+	// Gateway is nil unless the public download gateway is enabled
+	Gateway            *gateway.Gateway
+	RetentionEndpoints *endpoints.RetentionEndpoints
+	BatchEndpoints     *endpoints.BatchEndpoints
+	// LifecycleEndpoints is nil when lifecycle rules are unavailable
+	LifecycleEndpoints *endpoints.LifecycleEndpoints
+	lifecycleScheduler *lifecycle.Scheduler
+	BackupEndpoints    *endpoints.BackupEndpoints
+	backupScheduler    *backup.Scheduler
+	// DirectoryEndpoints is nil without the metadata-backed file service
+	DirectoryEndpoints *endpoints.DirectoryEndpoints
+	// NFS is nil unless NFS exports are configured; callers serve it on
+	// its own listener
+	NFS *nfs.Server
+	// SFTP is nil unless SFTP users are configured; callers serve it on
+	// its own listener
+	SFTP *sftp.Server
+	// Registry is nil unless the container registry is enabled
+	Registry *registry.Registry
+	// SnapshotEndpoints is nil when snapshots are unavailable
+	SnapshotEndpoints *endpoints.SnapshotEndpoints
+	snapshots         *snapshot.Manager
+	// GeoReplicationEndpoints is nil unless geo-replication is configured
+	GeoReplicationEndpoints *endpoints.GeoReplicationEndpoints
+	geoReplication          *georeplication.Node
+	// TopologyEndpoints is nil unless the API runs on a PeerVault node
+	TopologyEndpoints *endpoints.TopologyEndpoints
+	// ReplicaEndpoints is nil unless the API runs on a PeerVault node
+	ReplicaEndpoints *endpoints.ReplicaEndpoints
+	// ConflictEndpoints is nil unless the API runs on a PeerVault node
+	ConflictEndpoints *endpoints.ConflictEndpoints
+	// DocumentEndpoints is nil unless the API runs on a PeerVault node
+	DocumentEndpoints *endpoints.DocumentEndpoints
+	// AnalyticsEndpoints is nil unless the node collects access analytics
+	AnalyticsEndpoints *endpoints.AnalyticsEndpoints
+	// ReportEndpoints is nil unless the node runs analytics reports
+	ReportEndpoints *endpoints.ReportEndpoints
+	// AlertEndpoints is nil unless the node evaluates alert rules
+	AlertEndpoints *endpoints.AlertEndpoints
+	// GrafanaEndpoints is nil unless the API runs on a PeerVault node
+	GrafanaEndpoints *endpoints.GrafanaEndpoints
+	// LeaseEndpoints is nil unless a Raft group is configured
+	LeaseEndpoints *endpoints.LeaseEndpoints
+	// PeerACLEndpoints is nil unless the node has a peer ACL
+	PeerACLEndpoints *endpoints.PeerACLEndpoints
+	// JoinTokenEndpoints is nil unless the node admits nodes with join
+	// tokens
+	JoinTokenEndpoints *endpoints.JoinTokenEndpoints
+	// DeviceEndpoints is nil unless the node runs the device CA
+	DeviceEndpoints *endpoints.DeviceEndpoints
+	// MQTTACLEndpoints is nil unless the node has an MQTT topic ACL
+	MQTTACLEndpoints *endpoints.MQTTACLEndpoints
+	// LwM2MEndpoints is nil unless the node runs the LwM2M server
+	LwM2MEndpoints *endpoints.LwM2MEndpoints
+	// FirmwareEndpoints is nil unless the node distributes firmware
+	FirmwareEndpoints *endpoints.FirmwareEndpoints
+	// KeyEndpoints is nil unless the API runs on a PeerVault node
+	KeyEndpoints *endpoints.KeyEndpoints
+	// DecommissionEndpoints is nil unless the API runs on a PeerVault node
+	DecommissionEndpoints *endpoints.DecommissionEndpoints
+	// FsckEndpoints is nil unless the API runs on a PeerVault node
+	FsckEndpoints *endpoints.FsckEndpoints
+	// JobEndpoints is nil unless the API runs on a PeerVault node
+	JobEndpoints *endpoints.JobEndpoints
+	// jobs runs lifecycle runs and backups with the node's other periodic
+	// work; nil runs them on their own
+	jobs *scheduler.Scheduler
+	// QueueEndpoints is nil unless the API runs on a PeerVault node
+	QueueEndpoints *endpoints.QueueEndpoints
+	// PolicyEndpoints is nil unless the node evaluates a policy
+	PolicyEndpoints *endpoints.PolicyEndpoints
+	// HookEndpoints is nil unless the node runs hooks
+	HookEndpoints *endpoints.HookEndpoints
+	// ExportEndpoints is nil unless the node has exports
+	ExportEndpoints *endpoints.ExportEndpoints
+	// MediaEndpoints is nil unless thumbnails are enabled
+	MediaEndpoints *endpoints.MediaEndpoints
+	// JSONEndpoints is nil without the metadata-backed file service
+	JSONEndpoints *endpoints.JSONEndpoints
+	// QueryEndpoints is nil without the metadata-backed file service
+	QueryEndpoints *endpoints.QueryEndpoints
+	// LogEndpoints is nil unless logs are enabled
+	LogEndpoints *endpoints.LogEndpoints
+	logs         *implementations.LogServiceImpl
 }
 
 type Config struct {
@@ -30,30 +152,132 @@ type Config struct {
 	AllowedOrigins  []string
 	RateLimitPerMin int
 	AuthToken       string
+	// AuthProviders are asked about requests without AuthToken, such as
+	// those of auth plugins; the first to accept a request admits it
+	AuthProviders []plugin.Auth
+	// Security sets the CSRF protection and security headers of responses
+	Security        httpguard.Policy
 	VersionConfig   *versioning.VersionConfig
 	RateLimitConfig *ratelimit.RateLimitConfig
+	// SearchEnabled turns on full-text indexing of uploaded documents
+	SearchEnabled bool
+	// SearchIndexPath persists the search index; empty keeps it in memory
+	SearchIndexPath string
+	// ShareSecret signs share links; a random secret is generated when
+	// empty, which invalidates outstanding links on restart
+	ShareSecret string
+	// ShareLinksPath persists share links; empty keeps them in memory
+	ShareLinksPath string
+	// PublicBaseURL prefixes share link URLs, e.g. https://vault.example.com
+	PublicBaseURL string
+	// GatewayConfig configures anonymous read-only access to whitelisted files
+	GatewayConfig *gateway.Config
+	// Retention holds retention locks and legal holds; an in-memory
+	// manager is used when nil
+	Retention *retention.Manager
+	// LifecyclePolicyPath persists lifecycle rules; empty keeps them in memory
+	LifecyclePolicyPath string
+	// LifecycleInterval is how often lifecycle rules are evaluated
+	LifecycleInterval time.Duration
+	// LifecycleEnforce applies due actions on scheduled runs. Off by default
+	// so scheduled runs only report until the policy has been reviewed.
+	LifecycleEnforce bool
+	// Backup lists the backup jobs and their schedules; nil runs no jobs
+	Backup *backup.Config
+	// Snapshots persists snapshots and schedules them; nil keeps snapshots
+	// in memory and takes them on demand only
+	Snapshots *snapshot.Config
+	// Media makes thumbnails of stored images and videos and records their
+	// dimensions; nil disables it
+	Media *media.Config
+	// JSONSchemas validates JSON documents by key prefix; nil accepts any
+	// JSON
+	JSONSchemas *jsondoc.Schemas
+	// NFS exports namespaces of the files over NFSv3; nil disables it
+	NFS *nfs.Config
+	// SFTP serves the files to SSH users over SFTP and scp; nil disables
+	// it
+	SFTP *sftp.Config
+	// Registry serves the OCI distribution API of container registries
+	// under /v2/; nil disables it
+	Registry *registry.Config
+	// Logs keeps append-only logs of records in the file store; nil
+	// disables them
+	Logs *streamlog.Config
+	// GeoReplication replicates files to and from other clusters; nil
+	// disables it
+	GeoReplication *georeplication.Config
+	// UploadDir stages the parts of resumable uploads; empty uses a
+	// directory under the system temp dir
+	UploadDir string
+	// FileServer stores file content on a PeerVault node shared with the
+	// node's other APIs; nil keeps content in memory
+	FileServer *fileserver.Server
+	// Cluster reaches the Raft group holding the cluster metadata and
+	// serves its locks and leases; nil disables the lease API
+	Cluster *consensus.Client
+	// TLSConfig serves the API over HTTPS; nil serves plain HTTP
+	TLSConfig *tls.Config
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		Port:            ":8081",
-		ReadTimeout:     15 * time.Second,
-		WriteTimeout:    15 * time.Second,
-		MaxHeaderBytes:  1 << 20,
-		AllowedOrigins:  []string{"*"},
-		RateLimitPerMin: 100,
-		AuthToken:       "demo-token",
-		VersionConfig:   versioning.NewVersionConfig(),
-		RateLimitConfig: ratelimit.DefaultConfig(),
+		Port:              ":8081",
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      15 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+		AllowedOrigins:    []string{"*"},
+		RateLimitPerMin:   100,
+		AuthToken:         "demo-token",
+		Security:          httpguard.DefaultPolicy(),
+		VersionConfig:     versioning.NewVersionConfig(),
+		RateLimitConfig:   ratelimit.DefaultConfig(),
+		GatewayConfig:     gateway.DefaultConfig(),
+		LifecycleInterval: time.Hour,
 	}
 }
 
 func NewServer(config *Config, logger *slog.Logger) *Server {
+	// Initialize the optional search index
+	var searchIndex *search.Index
+	if config.SearchEnabled {
+		var err error
+		searchIndex, err = search.NewIndex(search.Options{Path: config.SearchIndexPath})
+		if err != nil {
+			logger.Error("Failed to open search index, search disabled", "error", err)
+			searchIndex = nil
+		}
+	}
+
 	// Initialize services
-	fileService := implementations.NewFileService()
+	locks := config.Retention
+	if locks == nil {
+		locks, _ = retention.NewManager(retention.ManagerOpts{})
+	}
+	var fileService services.FileService
+	if config.FileServer != nil {
+		fileService = implementations.NewFileServiceWithFileServer(config.FileServer, searchIndex, locks)
+	} else {
+		fileService = implementations.NewFileServiceWithRetention(searchIndex, locks)
+	}
 	peerService := implementations.NewPeerService()
+	if config.FileServer != nil {
+		peerService = implementations.NewPeerServiceWithFileServer(config.FileServer)
+	}
 	systemService := implementations.NewSystemService()
 
+	shareManager, err := newShareManager(config, logger)
+	if err != nil {
+		logger.Error("Failed to load share links, starting with none", "error", err)
+		config.ShareLinksPath = ""
+		shareManager, _ = newShareManager(config, logger)
+	}
+	var rules *policy.Engine
+	if config.FileServer != nil {
+		rules = config.FileServer.Policy
+	}
+	shareService := implementations.NewShareService(shareManager, fileService, rules)
+
 	// Initialize rate limiter
 	rateLimiter := ratelimit.NewRateLimiter(config.RateLimitConfig)
 
@@ -61,49 +285,257 @@ func NewServer(config *Config, logger *slog.Logger) *Server {
 	fileEndpoints := endpoints.NewFileEndpoints(fileService, logger)
 	peerEndpoints := endpoints.NewPeerEndpoints(peerService, logger)
 	systemEndpoints := endpoints.NewSystemEndpoints(systemService, logger)
+	shareEndpoints := endpoints.NewShareEndpoints(shareService, logger)
+	retentionEndpoints := endpoints.NewRetentionEndpoints(implementations.NewRetentionService(locks, fileService), logger)
+	batchEndpoints := endpoints.NewBatchEndpoints(implementations.NewBatchService(fileService), logger)
 
-	return &Server{
-		config:          config,
-		logger:          logger,
-		rateLimiter:     rateLimiter,
-		FileEndpoints:   fileEndpoints,
-		PeerEndpoints:   peerEndpoints,
-		SystemEndpoints: systemEndpoints,
+	server := &Server{
+		config:             config,
+		logger:             logger,
+		rateLimiter:        rateLimiter,
+		FileEndpoints:      fileEndpoints,
+		PeerEndpoints:      peerEndpoints,
+		SystemEndpoints:    systemEndpoints,
+		ShareEndpoints:     shareEndpoints,
+		RetentionEndpoints: retentionEndpoints,
+		BatchEndpoints:     batchEndpoints,
+		searchIndex:        searchIndex,
+	}
+	if config.GatewayConfig != nil && config.GatewayConfig.Enabled {
+		server.Gateway = gateway.NewGateway(config.GatewayConfig, fileService, logger)
+	}
+	uploadDir := config.UploadDir
+	if uploadDir == "" {
+		uploadDir = filepath.Join(os.TempDir(), "peervault-uploads")
+	}
+	uploadManager, err := uploads.NewManager(uploads.ManagerOpts{Dir: uploadDir, Logger: logger})
+	if err != nil {
+		logger.Error("Failed to initialize upload staging, resumable uploads disabled", "error", err)
+	} else {
+		server.UploadEndpoints = endpoints.NewUploadEndpoints(implementations.NewUploadService(uploadManager, fileService), logger)
 	}
+	if directories, err := implementations.NewDirectoryService(fileService); err != nil {
+		logger.Error("Failed to initialize directories, directories disabled", "error", err)
+	} else {
+		server.DirectoryEndpoints = endpoints.NewDirectoryEndpoints(directories, logger)
+	}
+	if config.NFS != nil {
+		if store, err := implementations.NewNFSStore(fileService); err != nil {
+			logger.Error("Failed to initialize the NFS gateway, NFS disabled", "error", err)
+		} else if server.NFS, err = nfs.NewServer(store, config.NFS, logger); err != nil {
+			logger.Error("Failed to initialize the NFS gateway, NFS disabled", "error", err)
+		}
+	}
+	if config.SFTP != nil {
+		if store, err := implementations.NewSFTPStore(fileService); err != nil {
+			logger.Error("Failed to initialize the SFTP server, SFTP disabled", "error", err)
+		} else if server.SFTP, err = sftp.NewServer(store, config.SFTP, logger); err != nil {
+			logger.Error("Failed to initialize the SFTP server, SFTP disabled", "error", err)
+		}
+	}
+	if config.Registry != nil && uploadManager != nil {
+		if store, err := implementations.NewRegistryStore(fileService); err != nil {
+			logger.Error("Failed to initialize the container registry, registry disabled", "error", err)
+		} else if server.Registry, err = registry.New(store, uploadManager, config.Registry, logger); err != nil {
+			logger.Error("Failed to initialize the container registry, registry disabled", "error", err)
+		}
+	}
+	if documents, err := implementations.NewJSONService(fileService, config.JSONSchemas); err != nil {
+		logger.Error("Failed to initialize JSON documents, JSON documents disabled", "error", err)
+	} else {
+		server.JSONEndpoints = endpoints.NewJSONEndpoints(documents, logger)
+	}
+	if queries, err := implementations.NewQueryService(fileService); err != nil {
+		logger.Error("Failed to initialize SQL queries, queries disabled", "error", err)
+	} else {
+		server.QueryEndpoints = endpoints.NewQueryEndpoints(queries, logger)
+	}
+	if config.Logs != nil {
+		if logs, err := implementations.NewLogService(fileService, *config.Logs); err != nil {
+			logger.Error("Failed to initialize logs, logs disabled", "error", err)
+		} else {
+			server.logs = logs
+			server.LogEndpoints = endpoints.NewLogEndpoints(logs, logger)
+		}
+	}
+	if searchIndex != nil {
+		server.SearchEndpoints = endpoints.NewSearchEndpoints(implementations.NewSearchService(searchIndex), logger)
+	}
+
+	lifecycleScheduler, err := newLifecycleScheduler(config, fileService, logger)
+	if err != nil {
+		logger.Error("Failed to initialize lifecycle rules, lifecycle disabled", "error", err)
+	} else {
+		server.lifecycleScheduler = lifecycleScheduler
+		server.LifecycleEndpoints = endpoints.NewLifecycleEndpoints(implementations.NewLifecycleService(lifecycleScheduler), logger)
+	}
+
+	backupEngine, err := implementations.NewBackupEngine(fileService, logger)
+	if err != nil {
+		logger.Error("Failed to initialize backups, backups disabled", "error", err)
+	} else {
+		server.backupScheduler = backup.NewScheduler(backupEngine, config.Backup, logger)
+		server.BackupEndpoints = endpoints.NewBackupEndpoints(implementations.NewBackupService(server.backupScheduler), logger)
+	}
+
+	// Snapshots wrap the content store of the file service, so every write
+	// through it preserves the content they recorded
+	snapshots, err := implementations.NewSnapshotManager(fileService, config.Snapshots, logger)
+	if err != nil {
+		logger.Error("Failed to initialize snapshots, snapshots disabled", "error", err)
+	} else {
+		server.snapshots = snapshots
+		server.SnapshotEndpoints = endpoints.NewSnapshotEndpoints(implementations.NewSnapshotService(snapshots, server.backupScheduler), logger)
+	}
+
+	if config.Media != nil {
+		var jobs *queue.Queue
+		if config.FileServer != nil {
+			jobs = config.FileServer.Queue
+		}
+		if previews, err := implementations.NewMediaService(fileService, *config.Media, jobs, logger); err != nil {
+			logger.Error("Failed to initialize thumbnails, thumbnails disabled", "error", err)
+		} else {
+			server.MediaEndpoints = endpoints.NewMediaEndpoints(previews, logger)
+		}
+	}
+
+	if config.GeoReplication != nil {
+		node, err := newGeoReplicationNode(config.GeoReplication, fileService, logger)
+		if err != nil {
+			logger.Error("Failed to initialize geo-replication, geo-replication disabled", "error", err)
+		} else {
+			server.geoReplication = node
+			server.GeoReplicationEndpoints = endpoints.NewGeoReplicationEndpoints(implementations.NewGeoReplicationService(node), logger)
+		}
+	}
+
+	if config.FileServer != nil {
+		server.TopologyEndpoints = endpoints.NewTopologyEndpoints(implementations.NewTopologyService(config.FileServer), logger)
+		server.ReplicaEndpoints = endpoints.NewReplicaEndpoints(implementations.NewReplicaService(config.FileServer, fileService), logger)
+		server.ConflictEndpoints = endpoints.NewConflictEndpoints(implementations.NewConflictService(config.FileServer), logger)
+		server.DocumentEndpoints = endpoints.NewDocumentEndpoints(implementations.NewDocumentService(config.FileServer), logger)
+		server.GrafanaEndpoints = endpoints.NewGrafanaEndpoints(implementations.NewGrafanaService(config.FileServer), logger)
+		server.KeyEndpoints = endpoints.NewKeyEndpoints(implementations.NewKeyService(config.FileServer), logger)
+		server.DecommissionEndpoints = endpoints.NewDecommissionEndpoints(implementations.NewDecommissionService(config.FileServer), logger)
+		server.FsckEndpoints = endpoints.NewFsckEndpoints(implementations.NewFsckService(config.FileServer), logger)
+		if config.FileServer.Scheduler != nil {
+			server.jobs = config.FileServer.Scheduler
+			server.JobEndpoints = endpoints.NewJobEndpoints(implementations.NewJobService(server.jobs), logger)
+		}
+		if config.FileServer.Queue != nil {
+			server.QueueEndpoints = endpoints.NewQueueEndpoints(implementations.NewQueueService(config.FileServer.Queue), logger)
+		}
+		if config.FileServer.Analytics != nil {
+			server.AnalyticsEndpoints = endpoints.NewAnalyticsEndpoints(implementations.NewAnalyticsService(config.FileServer.Analytics), logger)
+		}
+		if config.FileServer.Reports != nil {
+			server.ReportEndpoints = endpoints.NewReportEndpoints(implementations.NewReportService(config.FileServer), logger)
+		}
+		if config.FileServer.Alerts != nil {
+			server.AlertEndpoints = endpoints.NewAlertEndpoints(implementations.NewAlertService(config.FileServer), logger)
+		}
+		if config.FileServer.PeerACL != nil {
+			server.PeerACLEndpoints = endpoints.NewPeerACLEndpoints(implementations.NewPeerACLService(config.FileServer), logger)
+		}
+		if config.FileServer.JoinTokens != nil {
+			server.JoinTokenEndpoints = endpoints.NewJoinTokenEndpoints(implementations.NewJoinTokenService(config.FileServer), logger)
+		}
+		if config.FileServer.Devices != nil {
+			server.DeviceEndpoints = endpoints.NewDeviceEndpoints(implementations.NewDeviceService(config.FileServer.Devices), logger)
+		}
+		if config.FileServer.TopicACL != nil {
+			server.MQTTACLEndpoints = endpoints.NewMQTTACLEndpoints(implementations.NewMQTTACLService(config.FileServer.TopicACL), logger)
+		}
+		if config.FileServer.LwM2M != nil {
+			server.LwM2MEndpoints = endpoints.NewLwM2MEndpoints(implementations.NewLwM2MService(config.FileServer.LwM2M), logger)
+		}
+		if config.FileServer.Firmware != nil {
+			server.FirmwareEndpoints = endpoints.NewFirmwareEndpoints(implementations.NewFirmwareService(config.FileServer.Firmware), logger)
+		}
+		if config.FileServer.Policy != nil {
+			server.PolicyEndpoints = endpoints.NewPolicyEndpoints(implementations.NewPolicyService(config.FileServer.Policy), logger)
+		}
+		if config.FileServer.Hooks != nil {
+			server.HookEndpoints = endpoints.NewHookEndpoints(implementations.NewHookService(config.FileServer.Hooks), logger)
+		}
+		if config.FileServer.Exports != nil {
+			server.ExportEndpoints = endpoints.NewExportEndpoints(implementations.NewExportService(config.FileServer), logger)
+		}
+	}
+	if config.Cluster != nil {
+		server.LeaseEndpoints = endpoints.NewLeaseEndpoints(implementations.NewLeaseService(config.Cluster), logger)
+	}
+
+	doc, err := OpenAPI()
+	if err != nil {
+		logger.Error("Failed to build the OpenAPI document", "error", err)
+	} else {
+		systemEndpoints.SetOpenAPI(doc)
+	}
+	if server.Gateway != nil {
+		systemEndpoints.SetGatewayCache(server.Gateway.CacheStats)
+	}
+	return server
 }
 
-func (s *Server) Start() error {
+func newGeoReplicationNode(config *georeplication.Config, files services.FileService, logger *slog.Logger) (*georeplication.Node, error) {
+	local, err := implementations.NewGeoReplicationLocal(files)
+	if err != nil {
+		return nil, err
+	}
+	return georeplication.NewNode(*config, local, logger)
+}
+
+func newLifecycleScheduler(config *Config, files services.FileService, logger *slog.Logger) (*lifecycle.Scheduler, error) {
+	target, err := implementations.NewLifecycleTarget(files)
+	if err != nil {
+		return nil, err
+	}
+	return lifecycle.NewScheduler(target, lifecycle.SchedulerOpts{
+		Interval:   config.LifecycleInterval,
+		Enforce:    config.LifecycleEnforce,
+		PolicyPath: config.LifecyclePolicyPath,
+		Logger:     logger,
+	})
+}
+
+func newShareManager(config *Config, logger *slog.Logger) (*sharing.Manager, error) {
+	secret := []byte(config.ShareSecret)
+	if len(secret) == 0 {
+		logger.Warn("No share link secret configured, links will not survive a restart")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	return sharing.NewManager(sharing.ManagerOpts{
+		Secret:  secret,
+		Path:    config.ShareLinksPath,
+		BaseURL: strings.TrimSuffix(config.PublicBaseURL, "/"),
+	})
+}
+
+// Handler returns the routes of the server behind its middleware
+func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 
 	// Apply middleware
 	versionMiddleware := versioning.VersionMiddleware(s.config.VersionConfig)
 	rateLimitMiddleware := s.rateLimiter.Middleware()
-	handler := s.CORSMiddleware(versionMiddleware(rateLimitMiddleware(s.authMiddleware(s.loggingMiddleware(mux)))))
-
-	// API routes
-	api := http.NewServeMux()
-	api.HandleFunc("GET /files", s.FileEndpoints.HandleListFiles)
-	api.HandleFunc("GET /files/get", s.FileEndpoints.HandleGetFile)
-	api.HandleFunc("POST /files", s.FileEndpoints.HandleUploadFile)
-	api.HandleFunc("DELETE /files", s.FileEndpoints.HandleDeleteFile)
-	api.HandleFunc("PUT /files/metadata", s.FileEndpoints.HandleUpdateFileMetadata)
-
-	api.HandleFunc("GET /peers", s.PeerEndpoints.HandleListPeers)
-	api.HandleFunc("GET /peers/get", s.PeerEndpoints.HandleGetPeer)
-	api.HandleFunc("POST /peers", s.PeerEndpoints.HandleAddPeer)
-	api.HandleFunc("DELETE /peers", s.PeerEndpoints.HandleRemovePeer)
-
-	// System routes
-	mux.HandleFunc("GET /health", s.SystemEndpoints.HandleHealth)
-	mux.HandleFunc("GET /metrics", s.SystemEndpoints.HandleMetrics)
-	mux.HandleFunc("GET /system", s.SystemEndpoints.HandleSystemInfo)
-	mux.HandleFunc("POST /webhook", s.SystemEndpoints.HandleWebhook)
-	mux.HandleFunc("GET /api", s.SystemEndpoints.HandleRoot)
-	mux.HandleFunc("GET /docs", s.SystemEndpoints.HandleDocs)
-	mux.HandleFunc("GET /swagger.json", s.SystemEndpoints.HandleSwaggerJSON)
-
-	// Mount API under /api/v1
-	mux.Handle("/api/v1/", http.StripPrefix("/api/v1", api))
+	handler := requestid.Middleware(apierror.Middleware(s.CORSMiddleware(versionMiddleware(rateLimitMiddleware(s.authMiddleware(s.loggingMiddleware(mux)))))))
+
+	// Routes come from the same table as the OpenAPI document
+	for _, r := range s.routes() {
+		if !r.disabled {
+			mux.Handle(r.Method+" "+r.Path, r.handler)
+		}
+	}
+	return handler
+}
+
+func (s *Server) Start() error {
+	handler := s.Handler()
 
 	s.httpServer = &http.Server{
 		Addr:           s.config.Port,
@@ -111,9 +543,40 @@ func (s *Server) Start() error {
 		ReadTimeout:    s.config.ReadTimeout,
 		WriteTimeout:   s.config.WriteTimeout,
 		MaxHeaderBytes: s.config.MaxHeaderBytes,
+		TLSConfig:      s.config.TLSConfig,
 	}
 
-	s.logger.Info("Starting REST API server", "port", s.config.Port)
+	if s.lifecycleScheduler != nil {
+		if s.jobs == nil {
+			s.lifecycleScheduler.Start()
+		} else if err := s.lifecycleScheduler.Register(s.jobs); err != nil {
+			s.logger.Error("Failed to schedule lifecycle runs with the node's jobs, running them on their own", "error", err)
+			s.lifecycleScheduler.Start()
+		}
+	}
+	if s.backupScheduler != nil {
+		if s.jobs == nil {
+			s.backupScheduler.Start()
+		} else if err := s.backupScheduler.Register(s.jobs); err != nil {
+			s.logger.Error("Failed to schedule backups with the node's jobs, running them on their own", "error", err)
+			s.backupScheduler.Unregister(s.jobs)
+			s.backupScheduler.Start()
+		}
+	}
+	if s.snapshots != nil {
+		s.snapshots.Start()
+	}
+	if s.geoReplication != nil {
+		s.geoReplication.Start()
+	}
+	if s.logs != nil {
+		s.logs.Start()
+	}
+
+	s.logger.Info("Starting REST API server", "port", s.config.Port, "tls", s.config.TLSConfig != nil)
+	if s.config.TLSConfig != nil {
+		return s.httpServer.ListenAndServeTLS("", "")
+	}
 	return s.httpServer.ListenAndServe()
 }
 
@@ -123,41 +586,79 @@ func (s *Server) Stop(ctx context.Context) error {
 		s.rateLimiter.Stop()
 	}
 
-	if s.httpServer != nil {
-		return s.httpServer.Shutdown(ctx)
+	if s.Gateway != nil {
+		s.Gateway.Stop()
 	}
-	return nil
-}
 
-func (s *Server) CORSMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
-		if origin != "" {
-			for _, allowedOrigin := range s.config.AllowedOrigins {
-				if allowedOrigin == "*" || allowedOrigin == origin {
-					w.Header().Set("Access-Control-Allow-Origin", origin)
-					break
-				}
-			}
+	if s.lifecycleScheduler != nil {
+		s.lifecycleScheduler.Stop()
+		if s.jobs != nil {
+			s.lifecycleScheduler.Unregister(s.jobs)
 		}
+	}
 
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	if s.backupScheduler != nil {
+		s.backupScheduler.Stop()
+		if s.jobs != nil {
+			s.backupScheduler.Unregister(s.jobs)
+		}
+	}
+	if s.snapshots != nil {
+		s.snapshots.Stop()
+	}
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+	if s.geoReplication != nil {
+		s.geoReplication.Stop()
+	}
+	if s.logs != nil {
+		s.logs.Stop()
+	}
+
+	if s.searchIndex != nil {
+		if err := s.searchIndex.Close(); err != nil {
+			s.logger.Error("Failed to flush search index", "error", err)
 		}
+	}
 
-		next.ServeHTTP(w, r)
-	})
+	if s.httpServer != nil {
+		return s.httpServer.Shutdown(ctx)
+	}
+	return nil
+}
+
+// CORSMiddleware answers CORS requests from the allowed origins and
+// applies CSRF protection and security headers
+func (s *Server) CORSMiddleware(next http.Handler) http.Handler {
+	return httpguard.Middleware(&httpguard.Config{
+		Policy:         s.config.Security,
+		AllowedOrigins: s.config.AllowedOrigins,
+		AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+		AllowedHeaders: []string{"Content-Type", "Authorization", "Accept-Version", "Range", "Content-Range", "If-Match", "If-None-Match",
+			endpoints.SharePasswordHeader, endpoints.PartChecksumHeader, requestid.Header},
+		ExposedHeaders: []string{"ETag", "Content-Range", "Retry-After", "Location", "Docker-Content-Digest", "Docker-Upload-UUID", "X-API-Version", "X-API-Deprecated", requestid.Header},
+	}, next)
 }
 
 func (s *Server) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Skip auth for health check and docs
-		if r.URL.Path == "/health" || r.URL.Path == "/docs" || r.URL.Path == "/swagger.json" || r.URL.Path == "/api" {
+		// Skip auth for health check, docs, signed share links, the public
+		// gateway, signed replication batches and EST, where devices
+		// prove their own secret
+		if r.URL.Path == "/health" || r.URL.Path == "/docs" || r.URL.Path == "/swagger.json" || r.URL.Path == "/api" ||
+			strings.HasPrefix(r.URL.Path, sharing.PathPrefix) ||
+			(s.Gateway != nil && strings.HasPrefix(r.URL.Path, gateway.PathPrefix)) ||
+			(s.geoReplication != nil && r.URL.Path == georeplication.Path) ||
+			(s.DeviceEndpoints != nil && strings.HasPrefix(r.URL.Path, devices.ESTPath+"/")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Registry clients send the token as a password and expect a
+		// challenge in the registry's error format
+		if s.Registry != nil && strings.HasPrefix(r.URL.Path, registry.PathPrefix) {
+			if registry.Token(r) != s.config.AuthToken {
+				registry.Challenge(w)
+				return
+			}
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -169,7 +670,7 @@ func (s *Server) authMiddleware(next http.Handler) http.Handler {
 		}
 
 		expectedToken := "Bearer " + s.config.AuthToken
-		if authHeader != expectedToken {
+		if authHeader != expectedToken && !s.authenticateWithProviders(r) {
 			http.Error(w, "Invalid authorization token", http.StatusUnauthorized)
 			return
 		}
@@ -178,17 +679,45 @@ func (s *Server) authMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// authenticateWithProviders asks the auth providers about the bearer token
+// or the basic auth username and password of r
+func (s *Server) authenticateWithProviders(r *http.Request) bool {
+	if len(s.config.AuthProviders) == 0 {
+		return false
+	}
+	var creds plugin.Credentials
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		creds.Token = token
+	} else if username, password, ok := r.BasicAuth(); ok {
+		creds.Username, creds.Password = username, password
+	} else {
+		return false
+	}
+	for _, provider := range s.config.AuthProviders {
+		identity, err := provider.Authenticate(r.Context(), creds)
+		if err == nil && identity != nil {
+			s.logger.DebugContext(r.Context(), "Request authenticated by provider", "subject", identity.Subject, "path", r.URL.Path)
+			return true
+		}
+		if err != nil && !errors.Is(err, plugin.ErrDenied) {
+			s.logger.WarnContext(r.Context(), "Auth provider failed", "error", err)
+		}
+	}
+	return false
+}
+
 func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		next.ServeHTTP(w, r)
 		duration := time.Since(start)
 
-		s.logger.Info("HTTP request",
+		s.logger.InfoContext(r.Context(), "HTTP request",
 			"method", r.Method,
 			"path", r.URL.Path,
 			"remote_addr", r.RemoteAddr,
 			"duration", duration,
+			"request_id", requestid.FromContext(r.Context()),
 		)
 	})
 }
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/alerting"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+)
+
+// AlertService defines the interface for the node's alerting engine
+type AlertService interface {
+	// ListAlerts lists the state of every rule
+	ListAlerts(ctx context.Context) []alerting.Alert
+
+	// ListRules lists the alert rules
+	ListRules(ctx context.Context) []alerting.Rule
+
+	// PutRule creates or replaces an alert rule
+	PutRule(ctx context.Context, name string, req *requests.AlertRuleRequest) (alerting.Rule, error)
+
+	// DeleteRule removes an alert rule
+	DeleteRule(ctx context.Context, name string) error
+
+	// ListChannels lists the notification channels
+	ListChannels(ctx context.Context) []alerting.Channel
+
+	// PutChannel creates or replaces a notification channel
+	PutChannel(ctx context.Context, name string, req *requests.AlertChannelRequest) (alerting.Channel, error)
+
+	// DeleteChannel removes a notification channel
+	DeleteChannel(ctx context.Context, name string) error
+
+	// ListSilences lists the silences that have not ended
+	ListSilences(ctx context.Context) []alerting.Silence
+
+	// AddSilence mutes notifications for a while
+	AddSilence(ctx context.Context, req *requests.SilenceRequest) (alerting.Silence, error)
+
+	// DeleteSilence ends a silence early
+	DeleteSilence(ctx context.Context, id string) error
+
+	// ListMetrics lists the latest value of every metric observed
+	ListMetrics(ctx context.Context) []alerting.Sample
+
+	// PushMetrics records metric values pushed by clients
+	PushMetrics(ctx context.Context, req *requests.MetricsRequest) error
+}
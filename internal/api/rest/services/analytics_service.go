@@ -0,0 +1,19 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/analytics"
+)
+
+// AnalyticsService defines the interface for the access rollups of a node
+type AnalyticsService interface {
+	// GetRollups retrieves the access rollups selected by q
+	GetRollups(ctx context.Context, q analytics.Query) ([]analytics.Rollup, error)
+
+	// GetTop retrieves the most accessed keys, tenants or peers
+	GetTop(ctx context.Context, q analytics.Query, limit int) ([]analytics.Total, error)
+
+	// BucketSeconds returns the length of a rollup in seconds
+	BucketSeconds() int64
+}
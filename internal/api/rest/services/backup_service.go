@@ -0,0 +1,31 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/backup"
+)
+
+// BackupService defines the interface for backup jobs and snapshots
+type BackupService interface {
+	// ListJobs retrieves the configured jobs and their recent activity
+	ListJobs(ctx context.Context) ([]backup.JobStatus, error)
+
+	// RunBackup starts a backup of a job in the background
+	RunBackup(ctx context.Context, job string, typ backup.BackupType) error
+
+	// ListSnapshots retrieves a job's snapshots, oldest first
+	ListSnapshots(ctx context.Context, job string) ([]backup.Snapshot, error)
+
+	// GetSnapshot retrieves a snapshot including its entries
+	GetSnapshot(ctx context.Context, job, id string) (*backup.Snapshot, error)
+
+	// VerifySnapshot checks every blob of a snapshot against its checksum
+	VerifySnapshot(ctx context.Context, job, id string) (*backup.VerifyReport, error)
+
+	// DeleteSnapshot removes a snapshot no other snapshot depends on
+	DeleteSnapshot(ctx context.Context, job, id string) error
+
+	// Restore writes a snapshot's files back at their original keys
+	Restore(ctx context.Context, job string, opts backup.RestoreOptions) (*backup.RestoreReport, error)
+}
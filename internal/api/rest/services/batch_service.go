@@ -0,0 +1,15 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/batch"
+)
+
+// BatchService defines the interface for batches of mixed file operations
+type BatchService interface {
+	// Execute runs a validated batch, at most parallelism operations at
+	// once, and returns the outcome of each in order. Stores and copies
+	// return the *types.File they wrote, tags the file they changed.
+	Execute(ctx context.Context, ops []batch.Operation, parallelism int) []batch.Outcome
+}
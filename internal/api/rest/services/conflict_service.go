@@ -0,0 +1,28 @@
+package services
+
+import (
+	"context"
+	"io"
+
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+)
+
+// ConflictService defines the interface for files with versions written
+// concurrently on different nodes
+type ConflictService interface {
+	// ListConflicts retrieves the files this node holds conflicting
+	// versions of
+	ListConflicts(ctx context.Context) []fileserver.FileVersions
+
+	// GetVersions retrieves the versions of a file this node holds
+	GetVersions(ctx context.Context, key string) (fileserver.FileVersions, error)
+
+	// GetVersionContent retrieves the content of one version of a file
+	GetVersionContent(ctx context.Context, key, id string) ([]byte, error)
+
+	// PickVersion resolves a conflict by keeping one of the versions
+	PickVersion(ctx context.Context, key, id string) (fileserver.FileVersion, error)
+
+	// MergeVersions resolves a conflict with content merged by the caller
+	MergeVersions(ctx context.Context, key string, content io.Reader) (fileserver.FileVersion, error)
+}
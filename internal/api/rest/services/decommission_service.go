@@ -0,0 +1,20 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+)
+
+// DecommissionService defines the interface for taking the node out of the
+// cluster once its files are moved to the other nodes
+type DecommissionService interface {
+	// Status returns the phase and progress of the decommission
+	Status(ctx context.Context) fileserver.DecommissionStatus
+
+	// Start takes the node off the ring and starts moving its files
+	Start(ctx context.Context) (fileserver.DecommissionStatus, error)
+
+	// Abort stops moving files and puts the node back on the ring
+	Abort(ctx context.Context) (fileserver.DecommissionStatus, error)
+}
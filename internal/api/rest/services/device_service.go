@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/devices"
+)
+
+// DeviceService defines the interface for the IoT devices enrolling for
+// client certificates with the node's device CA
+type DeviceService interface {
+	// ListDevices lists the devices, oldest first, without their secrets
+	ListDevices(ctx context.Context) []devices.Device
+
+	// GetDevice returns a device and its certificates
+	GetDevice(ctx context.Context, id string) (devices.Device, error)
+
+	// RegisterDevice registers a device, returning the secret it enrolls
+	// with
+	RegisterDevice(ctx context.Context, req *requests.DeviceRequest, actor string) (devices.Device, string, error)
+
+	// ResetSecret gives a device a new secret and lets it enroll again
+	ResetSecret(ctx context.Context, id, actor string) (devices.Device, string, error)
+
+	// RevokeDevice revokes a device and all its certificates
+	RevokeDevice(ctx context.Context, id, actor string) error
+
+	// RevokeCertificate revokes one certificate of a device
+	RevokeCertificate(ctx context.Context, id, serial, actor string) error
+
+	// CACertificates returns the device CA as a PKCS#7 certs-only message
+	CACertificates(ctx context.Context) ([]byte, error)
+
+	// CRL returns the DER list of revoked device certificates
+	CRL(ctx context.Context) ([]byte, error)
+
+	// Enroll issues a certificate for a DER PKCS#10 request, returned as a
+	// PKCS#7 certs-only message. secret is the one the device sent with
+	// Basic auth, empty when it proves it in the request instead.
+	Enroll(ctx context.Context, csr []byte, secret string, renew bool, addr string) ([]byte, error)
+}
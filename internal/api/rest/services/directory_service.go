@@ -0,0 +1,29 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/directory"
+)
+
+// DirectoryService defines the interface for the folder view of the key
+// space
+type DirectoryService interface {
+	// ListDirectory lists the files and folders of a folder, or everything
+	// below it when recursive
+	ListDirectory(ctx context.Context, path string, recursive bool) (*directory.Listing, error)
+
+	// MakeDirectory creates a directory object, so the folder stays while
+	// empty
+	MakeDirectory(ctx context.Context, path string) (*directory.Directory, error)
+
+	// DeleteDirectory deletes a file or folder; a folder holding files only
+	// when recursive
+	DeleteDirectory(ctx context.Context, path string, recursive bool) (*directory.Report, error)
+
+	// Copy copies a file or folder on the server
+	Copy(ctx context.Context, from, to string, overwrite bool) (*directory.Report, error)
+
+	// Move renames a file or folder on the server
+	Move(ctx context.Context, from, to string, overwrite bool) (*directory.Report, error)
+}
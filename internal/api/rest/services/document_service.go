@@ -0,0 +1,22 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/crdt"
+)
+
+// DocumentService defines the interface for shared documents, which every
+// node keeps a copy of and changes without coordination
+type DocumentService interface {
+	// ListDocuments retrieves the names of the shared documents
+	ListDocuments(ctx context.Context) []string
+
+	// GetDocument retrieves what a shared document holds
+	GetDocument(ctx context.Context, name string) (crdt.View, error)
+
+	// UpdateDocument applies changes to a shared document, creating it
+	// when it does not exist
+	UpdateDocument(ctx context.Context, name string, req *requests.DocumentUpdateRequest) (crdt.View, error)
+}
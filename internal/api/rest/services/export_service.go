@@ -0,0 +1,17 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/export"
+)
+
+// ExportService defines the interface for the CSV and Parquet exports of
+// metadata, accesses and rollups
+type ExportService interface {
+	// ListExports lists the exports with their last run
+	ListExports(ctx context.Context) []export.Info
+
+	// RunExport runs an export now and writes its file
+	RunExport(ctx context.Context, name string) (*export.Run, error)
+}
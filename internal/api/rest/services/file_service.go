@@ -4,6 +4,10 @@ import (
 	"context"
 
 	"github.com/Skpow1234/Peervault/internal/api/rest/types"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/etag"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+	"github.com/Skpow1234/Peervault/pkg/merkle"
 )
 
 // FileService defines the interface for file operations
@@ -11,15 +15,57 @@ type FileService interface {
 	// ListFiles retrieves all files
 	ListFiles(ctx context.Context) ([]types.File, error)
 
+	// SearchFiles retrieves files carrying all given tags and metadata pairs
+	SearchFiles(ctx context.Context, tags []string, metadata map[string]string) ([]types.File, error)
+
+	// GetUsage returns the usage under a directory prefix and of the
+	// directories up to depth levels below it
+	GetUsage(ctx context.Context, prefix string, depth int) ([]metadata.PrefixUsage, error)
+
+	// GetTenantUsage returns the usage of every tenant
+	GetTenantUsage(ctx context.Context) ([]metadata.TenantUsage, error)
+
 	// GetFile retrieves a file by key
 	GetFile(ctx context.Context, key string) (*types.File, error)
 
+	// DownloadFile retrieves a file together with its content
+	DownloadFile(ctx context.Context, key string) (*types.File, []byte, error)
+
+	// GetFileManifest returns the Merkle tree of a file's content
+	GetFileManifest(ctx context.Context, key string, chunkSize int64) (*merkle.Manifest, error)
+
 	// UploadFile uploads a new file
-	UploadFile(ctx context.Context, name string, data []byte, contentType string, metadata map[string]string) (*types.File, error)
+	UploadFile(ctx context.Context, name string, data []byte, contentType string, metadata map[string]string, tags []string) (*types.File, error)
+
+	// UploadFileAt uploads a file under the given key, such as a path in a
+	// folder, replacing the file stored there
+	UploadFileAt(ctx context.Context, key, name string, data []byte, contentType string, metadata map[string]string, tags []string) (*types.File, error)
+
+	// UploadFileIf uploads like UploadFileAt when the file stored at key
+	// meets cond, failing with etag.ErrPrecondition otherwise
+	UploadFileIf(ctx context.Context, key, name string, data []byte, contentType string, metadata map[string]string, tags []string, cond etag.Condition) (*types.File, error)
+
+	// CopyObject copies a file to another key, possibly of another tenant,
+	// without its content passing through the client
+	CopyObject(ctx context.Context, req *requests.FileCopyRequest) (*types.File, error)
+
+	// MoveObject moves a file to another key, possibly of another tenant
+	MoveObject(ctx context.Context, req *requests.FileCopyRequest) (*types.File, error)
+
+	// ComposeObject builds a file from existing files on the server, so
+	// parts uploaded separately are joined without being uploaded again
+	ComposeObject(ctx context.Context, req *requests.FileComposeRequest) (*types.File, error)
 
 	// DeleteFile deletes a file by key
 	DeleteFile(ctx context.Context, key string) error
 
+	// DeleteFileIf deletes a file when it meets cond, failing with
+	// etag.ErrPrecondition otherwise
+	DeleteFileIf(ctx context.Context, key string, cond etag.Condition) error
+
 	// UpdateFileMetadata updates file metadata
 	UpdateFileMetadata(ctx context.Context, key string, metadata map[string]string) (*types.File, error)
+
+	// PatchFileMetadata partially updates file tags and metadata
+	PatchFileMetadata(ctx context.Context, key string, patch *requests.FileMetadataPatchRequest) (*types.File, error)
 }
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/firmware"
+)
+
+// FirmwareService defines the interface for distributing firmware images
+// to IoT devices in staged rollouts
+type FirmwareService interface {
+	// ListImages lists the firmware images
+	ListImages(ctx context.Context) []firmware.Image
+
+	// GetImage returns a firmware image
+	GetImage(ctx context.Context, id string) (firmware.Image, error)
+
+	// UploadImage stores and signs a firmware image
+	UploadImage(ctx context.Context, name, version string, data []byte, comment, actor string) (firmware.Image, error)
+
+	// DeleteImage deletes a firmware image
+	DeleteImage(ctx context.Context, id, actor string) error
+
+	// ImageContent returns a firmware image and its data
+	ImageContent(ctx context.Context, id string) (firmware.Image, []byte, error)
+
+	// SigningKey returns the public key images are signed with
+	SigningKey(ctx context.Context) firmware.SigningKey
+
+	// ListRollouts lists the rollouts
+	ListRollouts(ctx context.Context) []firmware.RolloutStatus
+
+	// GetRollout returns a rollout and the status of its devices
+	GetRollout(ctx context.Context, id string) (firmware.RolloutStatus, []firmware.DeviceStatus, error)
+
+	// CreateRollout creates a rollout and releases its first ring
+	CreateRollout(ctx context.Context, req firmware.RolloutRequest, actor string) (firmware.Rollout, error)
+
+	// AdvanceRollout releases the next ring of a rollout
+	AdvanceRollout(ctx context.Context, id, actor string) (firmware.Rollout, error)
+
+	// PauseRollout stops a rollout offering its image
+	PauseRollout(ctx context.Context, id, reason, actor string) (firmware.Rollout, error)
+
+	// ResumeRollout lets a paused rollout offer its image again
+	ResumeRollout(ctx context.Context, id, actor string) (firmware.Rollout, error)
+
+	// CancelRollout ends a rollout for good
+	CancelRollout(ctx context.Context, id, actor string) error
+
+	// CheckUpdate returns the update offered to a device running a version
+	CheckUpdate(ctx context.Context, device, version string) (firmware.Manifest, error)
+
+	// ReportStatus records how a device installing an image is doing
+	ReportStatus(ctx context.Context, device string, report firmware.StatusReport) (firmware.DeviceStatus, error)
+
+	// GetDeviceStatus returns where a device is installing an image
+	GetDeviceStatus(ctx context.Context, device string) (firmware.DeviceStatus, error)
+}
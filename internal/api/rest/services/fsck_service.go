@@ -0,0 +1,15 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/storage"
+)
+
+// FsckService defines the interface for checking the invariants of the
+// node's local store
+type FsckService interface {
+	// Check verifies every object of the store and reports the invariants
+	// found broken
+	Check(ctx context.Context) (*storage.CheckReport, error)
+}
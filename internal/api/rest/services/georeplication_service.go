@@ -0,0 +1,14 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/georeplication"
+)
+
+// GeoReplicationService defines the interface for cross-cluster replication
+type GeoReplicationService interface {
+	// GetStatus retrieves replication progress and lag for every target and
+	// the batches received from every source cluster
+	GetStatus(ctx context.Context) (georeplication.Status, error)
+}
@@ -0,0 +1,20 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/grafana"
+)
+
+// GrafanaService defines the interface for the Grafana JSON datasource
+type GrafanaService interface {
+	// Search lists the metrics matching target, or the values of the label
+	// target names
+	Search(ctx context.Context, target string) ([]string, error)
+
+	// LabelValues lists the values of a label
+	LabelValues(ctx context.Context, label string) ([]string, error)
+
+	// Query answers the targets of a panel
+	Query(ctx context.Context, req *grafana.QueryRequest) ([]any, error)
+}
@@ -0,0 +1,15 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/hooks"
+)
+
+// HookService defines the interface for the WebAssembly hooks run on the
+// lifecycle of requests
+type HookService interface {
+	// ListHooks returns the enabled hooks with their counters, in the
+	// order they run
+	ListHooks(ctx context.Context) ([]hooks.Stats, error)
+}
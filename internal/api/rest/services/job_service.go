@@ -0,0 +1,24 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/scheduler"
+)
+
+// JobService defines the interface for the periodic jobs of the node
+type JobService interface {
+	// ListJobs lists the jobs with their schedule and last run
+	ListJobs(ctx context.Context) []scheduler.JobStatus
+
+	// GetJob retrieves a job with its recent runs
+	GetJob(ctx context.Context, name string) (*responses.JobResponse, error)
+
+	// UpdateJob changes the schedule of a job or pauses it
+	UpdateJob(ctx context.Context, name string, req *requests.JobRequest) (scheduler.JobStatus, error)
+
+	// RunJob starts a run of a job now
+	RunJob(ctx context.Context, name string) (scheduler.Run, error)
+}
@@ -0,0 +1,21 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/join"
+)
+
+// JoinTokenService defines the interface for the join tokens new nodes
+// present to join the cluster
+type JoinTokenService interface {
+	// ListTokens lists the tokens, oldest first, without their secrets
+	ListTokens(ctx context.Context) []join.Record
+
+	// CreateToken creates a token, returning it encoded for the new node
+	CreateToken(ctx context.Context, req *requests.JoinTokenRequest, actor string) (join.Record, string, error)
+
+	// RevokeToken revokes a token; nodes that joined with it stay members
+	RevokeToken(ctx context.Context, id, actor string) error
+}
@@ -0,0 +1,25 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/types"
+	"github.com/Skpow1234/Peervault/internal/jsondoc"
+)
+
+// JSONService defines the interface for JSON documents, small structured
+// records stored as files and updated with patches
+type JSONService interface {
+	// GetJSON retrieves a document and the file holding it
+	GetJSON(ctx context.Context, key string) (*types.File, []byte, error)
+
+	// PutJSON stores a document, reporting whether it was created
+	PutJSON(ctx context.Context, key string, doc []byte, cond jsondoc.Condition) (*types.File, []byte, bool, error)
+
+	// PatchJSON applies a JSON Patch or JSON Merge Patch, by its content
+	// type, to a document
+	PatchJSON(ctx context.Context, key, patchType string, patch []byte, cond jsondoc.Condition) (*types.File, []byte, error)
+
+	// DeleteJSON deletes a document
+	DeleteJSON(ctx context.Context, key string, cond jsondoc.Condition) error
+}
@@ -0,0 +1,19 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+)
+
+// KeyService defines the interface for the rotation of the key files are
+// encrypted with at rest
+type KeyService interface {
+	// Status returns the current key generation and the progress of the
+	// re-encryption of stored files
+	Status(ctx context.Context) (fileserver.KeyRotationStatus, error)
+
+	// Rotate moves to the next key generation and starts re-encrypting the
+	// stored files with it
+	Rotate(ctx context.Context) (fileserver.KeyRotationStatus, error)
+}
@@ -0,0 +1,24 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/consensus"
+)
+
+// LeaseService defines the interface for distributed locks and leases
+type LeaseService interface {
+	// ListLeases retrieves the leases that have not expired
+	ListLeases(ctx context.Context) ([]consensus.Lease, error)
+
+	// GetLease retrieves a lease that has not expired
+	GetLease(ctx context.Context, name string) (*consensus.Lease, error)
+
+	// AcquireLease acquires a lease, or renews it when the holder already
+	// holds it; the lease carries the fencing token of the acquisition
+	AcquireLease(ctx context.Context, name string, req *requests.LeaseAcquireRequest) (*consensus.Lease, error)
+
+	// ReleaseLease gives up a lease before it expires
+	ReleaseLease(ctx context.Context, name, holder string) error
+}
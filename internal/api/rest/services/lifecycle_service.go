@@ -0,0 +1,25 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/lifecycle"
+)
+
+// LifecycleService defines the interface for lifecycle rules
+type LifecycleService interface {
+	// GetPolicy retrieves the active policy
+	GetPolicy(ctx context.Context) (lifecycle.Policy, error)
+
+	// SetPolicy validates and replaces the policy
+	SetPolicy(ctx context.Context, policy lifecycle.Policy) error
+
+	// Run evaluates the policy now; a dry run only reports what is due
+	Run(ctx context.Context, dryRun bool) (*lifecycle.Report, error)
+
+	// LastReport retrieves the report of the most recent run, or nil
+	LastReport(ctx context.Context) (*lifecycle.Report, error)
+
+	// Enforcing reports whether scheduled runs apply actions
+	Enforcing() bool
+}
@@ -0,0 +1,33 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/streamlog"
+)
+
+// LogService defines the interface for append-only logs of records
+type LogService interface {
+	// ListLogs describes every log
+	ListLogs(ctx context.Context) ([]streamlog.Info, error)
+
+	// GetLog describes a log
+	GetLog(ctx context.Context, name string) (streamlog.Info, error)
+
+	// DeleteLog removes a log and its records
+	DeleteLog(ctx context.Context, name string) error
+
+	// AppendRecords adds records to the end of a log, creating it if needed
+	AppendRecords(ctx context.Context, name string, data [][]byte) ([]streamlog.Record, error)
+
+	// ReadRecords reads the records of a log a query selects
+	ReadRecords(ctx context.Context, name string, q streamlog.Query) ([]streamlog.Record, error)
+
+	// WaitRecords blocks until a log has a record at offset
+	WaitRecords(ctx context.Context, name string, offset uint64) error
+
+	// TruncateLog drops the records before offset and those older than
+	// before
+	TruncateLog(ctx context.Context, name string, offset uint64, before time.Time) (streamlog.Info, error)
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/lwm2m"
+)
+
+// LwM2MService defines the interface for managing the devices registered
+// with the LwM2M server of the CoAP API
+type LwM2MService interface {
+	// ListClients lists the registered devices
+	ListClients(ctx context.Context) []lwm2m.Registration
+
+	// GetClient returns the registration of a device
+	GetClient(ctx context.Context, endpoint string) (lwm2m.Registration, error)
+
+	// Read reads an object, object instance, resource or resource instance
+	Read(ctx context.Context, endpoint, path string) ([]lwm2m.Value, error)
+
+	// Write writes a resource
+	Write(ctx context.Context, endpoint, path string, value any, actor string) error
+
+	// Execute executes a resource
+	Execute(ctx context.Context, endpoint, path, args, actor string) error
+
+	// Observe observes a path, returning its current values
+	Observe(ctx context.Context, endpoint, path string) ([]lwm2m.Value, error)
+
+	// CancelObservation stops observing a path
+	CancelObservation(ctx context.Context, endpoint, path string) error
+
+	// UpdateFirmware starts a firmware update
+	UpdateFirmware(ctx context.Context, endpoint, packageURI, actor string) (lwm2m.FirmwareUpdate, error)
+
+	// ListObjects lists the objects whose resources are decoded by type
+	ListObjects(ctx context.Context) []lwm2m.ObjectDef
+}
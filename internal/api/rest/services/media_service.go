@@ -0,0 +1,26 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/types"
+)
+
+// MediaService defines the interface for previews of stored images and
+// videos, and streams of videos and audio
+type MediaService interface {
+	// GetThumbnail returns a file and its JPEG thumbnail whose longest edge
+	// is size pixels; 0 picks the default size
+	GetThumbnail(ctx context.Context, key string, size int) (*types.File, []byte, error)
+
+	// ThumbnailSizes lists the sizes thumbnails are made at
+	ThumbnailSizes() []int
+
+	// GetPlaylist returns a file and its HLS playlist in profile; "" is
+	// the master playlist listing the profiles
+	GetPlaylist(ctx context.Context, key, profile string) (*types.File, []byte, error)
+
+	// GetSegment returns a file and segment n of its stream in profile as
+	// MPEG-TS
+	GetSegment(ctx context.Context, key, profile string, n int) (*types.File, []byte, error)
+}
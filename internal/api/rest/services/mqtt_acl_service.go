@@ -0,0 +1,21 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/mqtt/topicacl"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+)
+
+// MQTTACLService defines the interface for the rules deciding which MQTT
+// topics clients may publish to and subscribe to
+type MQTTACLService interface {
+	// ListRules lists the rules, those of the configuration first
+	ListRules(ctx context.Context) []topicacl.Rule
+
+	// AddRule adds a rule
+	AddRule(ctx context.Context, req *requests.MQTTACLRuleRequest, actor string) (topicacl.Rule, error)
+
+	// RemoveRule removes a rule added through the API
+	RemoveRule(ctx context.Context, id, actor string) error
+}
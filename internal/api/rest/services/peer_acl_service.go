@@ -0,0 +1,21 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/acl"
+)
+
+// PeerACLService defines the interface for the rules deciding which peers
+// may connect to the node
+type PeerACLService interface {
+	// ListRules lists the rules, those of the configuration first
+	ListRules(ctx context.Context) []acl.Rule
+
+	// AddRule adds a rule and disconnects the connected peers it keeps out
+	AddRule(ctx context.Context, req *requests.PeerACLRuleRequest, actor string) (acl.Rule, error)
+
+	// RemoveRule removes a rule added through the API
+	RemoveRule(ctx context.Context, id, actor string) error
+}
@@ -0,0 +1,18 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/policy"
+)
+
+// PolicyService defines the interface for the rules evaluated on storing,
+// replicating and sharing files
+type PolicyService interface {
+	// GetPolicy returns the rules and whether they are only logged
+	GetPolicy(ctx context.Context) (policy.Policy, bool, error)
+
+	// ListDecisions returns up to limit recent decisions, newest first;
+	// denied only returns denials
+	ListDecisions(ctx context.Context, limit int, denied bool) ([]policy.Decision, error)
+}
@@ -0,0 +1,17 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/sqlquery"
+)
+
+// QueryService defines the interface for read-only SQL queries over the
+// metadata index
+type QueryService interface {
+	// Query runs a SELECT statement, returning at most maxRows rows (zero
+	// for the default)
+	Query(ctx context.Context, sql string, maxRows int) (*sqlquery.Result, error)
+	// ListTables returns the tables queries can select from, without rows
+	ListTables(ctx context.Context) ([]sqlquery.Table, error)
+}
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/queue"
+)
+
+// QueueService defines the interface for the background job queue of the
+// node
+type QueueService interface {
+	// GetStats counts the jobs by state and kind
+	GetStats(ctx context.Context) queue.Stats
+
+	// ListJobs lists the jobs in a state and of a kind, all when empty
+	ListJobs(ctx context.Context, state queue.State, kind string) []queue.Job
+
+	// GetJob retrieves a job
+	GetJob(ctx context.Context, id string) (queue.Job, error)
+
+	// RequeueJob runs a dead job again
+	RequeueJob(ctx context.Context, id string) (queue.Job, error)
+
+	// RequeueDead runs the dead jobs of a kind, all when empty, again
+	RequeueDead(ctx context.Context, kind string) int
+
+	// DeleteJob drops a pending or dead job
+	DeleteJob(ctx context.Context, id string) error
+}
@@ -0,0 +1,13 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+)
+
+// ReplicaService defines the interface for the replication status of files
+type ReplicaService interface {
+	// GetReplicas asks the peers which of them hold a copy of the file
+	GetReplicas(ctx context.Context, key string) (fileserver.ReplicaReport, error)
+}
@@ -0,0 +1,26 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/analytics"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+)
+
+// ReportService defines the interface for scheduled analytics reports
+type ReportService interface {
+	// ListReports lists the reports with their status
+	ListReports(ctx context.Context) []analytics.ReportInfo
+
+	// GetReport retrieves a report by name
+	GetReport(ctx context.Context, name string) (analytics.ReportInfo, error)
+
+	// PutReport creates or replaces a report
+	PutReport(ctx context.Context, name string, req *requests.ReportRequest) (analytics.ReportInfo, error)
+
+	// DeleteReport removes a report
+	DeleteReport(ctx context.Context, name string) error
+
+	// RunReport runs a report now and stores its output
+	RunReport(ctx context.Context, name string) (*analytics.ReportRun, error)
+}
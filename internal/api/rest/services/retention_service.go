@@ -0,0 +1,20 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/retention"
+)
+
+// RetentionService defines the interface for object locks
+type RetentionService interface {
+	// GetLock retrieves the lock of a file; unlocked files have an empty lock
+	GetLock(ctx context.Context, key string) (*retention.Lock, error)
+
+	// ListLocks retrieves every lock still in force
+	ListLocks(ctx context.Context) ([]retention.Lock, error)
+
+	// UpdateLock extends retention and/or places or releases a legal hold
+	UpdateLock(ctx context.Context, key string, req *requests.FileLockUpdateRequest, actor string) (*retention.Lock, error)
+}
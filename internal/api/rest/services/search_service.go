@@ -0,0 +1,19 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/search"
+)
+
+// SearchService defines the interface for full-text search
+type SearchService interface {
+	// Search ranks indexed documents against a query
+	Search(ctx context.Context, query search.Query) (*search.Results, error)
+
+	// Rebuild starts rebuilding the index in the background
+	Rebuild(ctx context.Context) error
+
+	// Status reports index statistics
+	Status(ctx context.Context) (*search.Stats, error)
+}
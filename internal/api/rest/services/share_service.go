@@ -0,0 +1,30 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/types"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/sharing"
+)
+
+// ShareService defines the interface for signed share links
+type ShareService interface {
+	// CreateLink issues a link for an existing file and returns its URL
+	CreateLink(ctx context.Context, req *requests.ShareLinkCreateRequest, createdBy string) (*sharing.Link, string, error)
+
+	// ListLinks retrieves the links for a file, or all links when key is empty
+	ListLinks(ctx context.Context, key string) ([]sharing.Link, error)
+
+	// GetLink retrieves a link by ID
+	GetLink(ctx context.Context, id string) (*sharing.Link, error)
+
+	// RevokeLink disables a link
+	RevokeLink(ctx context.Context, id, revokedBy string) (*sharing.Link, error)
+
+	// LinkURL returns the signed URL of a link
+	LinkURL(link *sharing.Link) string
+
+	// OpenLink authorizes an access attempt and returns the shared file
+	OpenLink(ctx context.Context, access sharing.Access) (*types.File, []byte, error)
+}
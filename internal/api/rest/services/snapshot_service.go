@@ -0,0 +1,37 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/backup"
+	"github.com/Skpow1234/Peervault/internal/snapshot"
+)
+
+// SnapshotService defines the interface for point-in-time snapshots of
+// namespaces
+type SnapshotService interface {
+	// ListSnapshots retrieves every snapshot, oldest first, without entries
+	ListSnapshots(ctx context.Context) ([]snapshot.Snapshot, error)
+
+	// CreateSnapshot snapshots the files whose keys start with namespace
+	CreateSnapshot(ctx context.Context, namespace, name string) (*snapshot.Snapshot, error)
+
+	// GetSnapshot retrieves a snapshot including its entries
+	GetSnapshot(ctx context.Context, id string) (*snapshot.Snapshot, error)
+
+	// DeleteSnapshot removes a snapshot and the content only it preserved
+	DeleteSnapshot(ctx context.Context, id string) error
+
+	// DownloadFile reads a file as the snapshot recorded it
+	DownloadFile(ctx context.Context, id, key string) (*backup.Entry, []byte, error)
+
+	// Diff compares a snapshot with another one, or with the live files
+	// when to is empty
+	Diff(ctx context.Context, from, to string) (*snapshot.Diff, error)
+
+	// Clone writes the files of a snapshot under another prefix
+	Clone(ctx context.Context, id, prefix string, overwrite bool) (*snapshot.CloneReport, error)
+
+	// Backup runs a backup job from a snapshot instead of the live files
+	Backup(ctx context.Context, id, job string, typ backup.BackupType) (*backup.Snapshot, error)
+}
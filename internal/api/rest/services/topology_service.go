@@ -0,0 +1,14 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/peer"
+)
+
+// TopologyService defines the interface for the peer latency map
+type TopologyService interface {
+	// GetTopology retrieves the round trip times measured between the node
+	// and its peers, and between the peers themselves
+	GetTopology(ctx context.Context) (peer.Topology, error)
+}
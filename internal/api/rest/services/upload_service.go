@@ -0,0 +1,28 @@
+package services
+
+import (
+	"context"
+	"io"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/types"
+	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
+	"github.com/Skpow1234/Peervault/internal/uploads"
+)
+
+// UploadService defines the interface for resumable chunked uploads
+type UploadService interface {
+	// CreateUpload starts an upload of a file sent in parts
+	CreateUpload(ctx context.Context, req *requests.UploadCreateRequest) (*uploads.Upload, error)
+
+	// GetUpload retrieves an upload and the parts received so far
+	GetUpload(ctx context.Context, id string) (*uploads.Upload, error)
+
+	// UploadPart stores one part; a non-empty checksum is its hex SHA-256
+	UploadPart(ctx context.Context, id string, part int, r io.Reader, checksum string) (string, error)
+
+	// CompleteUpload stores the assembled file once every part has arrived
+	CompleteUpload(ctx context.Context, id string) (*types.File, error)
+
+	// AbortUpload discards an upload and its parts
+	AbortUpload(ctx context.Context, id string) error
+}
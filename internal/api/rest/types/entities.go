@@ -9,9 +9,11 @@ type File struct {
 	Size        int64             `json:"size"`
 	ContentType string            `json:"content_type"`
 	Hash        string            `json:"hash"`
+	MerkleRoot  string            `json:"merkle_root,omitempty"`
 	CreatedAt   time.Time         `json:"created_at"`
 	UpdatedAt   time.Time         `json:"updated_at"`
 	Metadata    map[string]string `json:"metadata,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
 	Replicas    []FileReplica     `json:"replicas,omitempty"`
 }
 
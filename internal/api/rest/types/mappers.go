@@ -28,9 +28,11 @@ func FileToResponse(file *File) *responses.FileResponse {
 		Size:        file.Size,
 		ContentType: file.ContentType,
 		Hash:        file.Hash,
+		MerkleRoot:  file.MerkleRoot,
 		CreatedAt:   file.CreatedAt,
 		UpdatedAt:   file.UpdatedAt,
 		Metadata:    file.Metadata,
+		Tags:        file.Tags,
 		Replicas:    replicas,
 	}
 }
@@ -72,9 +74,11 @@ func ResponseToFile(response *responses.FileResponse) *File {
 		Size:        response.Size,
 		ContentType: response.ContentType,
 		Hash:        response.Hash,
+		MerkleRoot:  response.MerkleRoot,
 		CreatedAt:   response.CreatedAt,
 		UpdatedAt:   response.UpdatedAt,
 		Metadata:    response.Metadata,
+		Tags:        response.Tags,
 		Replicas:    replicas,
 	}
 }
@@ -0,0 +1,54 @@
+package requests
+
+import (
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/alerting"
+)
+
+// AlertRuleRequest defines an alert rule, named by the path
+type AlertRuleRequest struct {
+	Metric string `json:"metric"`
+	// Kind is threshold, absence or rate; empty is threshold
+	Kind alerting.Kind `json:"kind,omitempty"`
+	// Op is one of >, >=, <, <=, == and !=
+	Op        string  `json:"op,omitempty"`
+	Threshold float64 `json:"threshold"`
+	// Window is the span of a rate, or how long an absent metric may go
+	// unreported, e.g. 5m
+	Window string `json:"window,omitempty"`
+	// For is how long the condition must hold before the alert fires
+	For      string            `json:"for,omitempty"`
+	Severity alerting.Severity `json:"severity,omitempty"`
+	Summary  string            `json:"summary,omitempty"`
+	// Channels are the names of the channels notified
+	Channels []string `json:"channels,omitempty"`
+}
+
+// AlertChannelRequest defines a notification channel, named by the path
+type AlertChannelRequest struct {
+	// Type is webhook, slack or email
+	Type alerting.ChannelType `json:"type"`
+	// URL is the webhook of webhook and Slack channels
+	URL string `json:"url,omitempty"`
+	// To are the recipients of email channels
+	To []string `json:"to,omitempty"`
+}
+
+// SilenceRequest mutes the notifications of a rule, or of every rule when
+// Rule is empty, until EndsAt or for Duration
+type SilenceRequest struct {
+	Rule     string    `json:"rule,omitempty"`
+	StartsAt time.Time `json:"starts_at,omitzero"`
+	EndsAt   time.Time `json:"ends_at,omitzero"`
+	// Duration is an alternative to EndsAt, e.g. 2h
+	Duration  string `json:"duration,omitempty"`
+	Comment   string `json:"comment,omitempty"`
+	CreatedBy string `json:"created_by,omitempty"`
+}
+
+// MetricsRequest pushes metric values to the alerting engine, e.g. the
+// heartbeats of jobs watched by absence rules
+type MetricsRequest struct {
+	Metrics map[string]float64 `json:"metrics"`
+}
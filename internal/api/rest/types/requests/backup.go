@@ -0,0 +1,11 @@
+package requests
+
+// BackupRestoreRequest selects the snapshot to restore. Without a snapshot
+// ID, the latest snapshot completed at or before At (RFC 3339) is used, or
+// the latest overall when At is empty.
+type BackupRestoreRequest struct {
+	Snapshot  string `json:"snapshot,omitempty"`
+	At        string `json:"at,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+}
@@ -0,0 +1,12 @@
+package requests
+
+// DeviceRequest represents a request to register an IoT device
+type DeviceRequest struct {
+	// ID is the common name of the device's certificates: 1 to 64
+	// letters, digits, '.', '_', ':' or '-'
+	ID string `json:"id"`
+	// Tenant is the organization of the device's certificates, which
+	// MQTT topic ACLs bind rules to
+	Tenant  string `json:"tenant,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
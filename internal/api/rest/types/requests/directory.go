@@ -0,0 +1,9 @@
+package requests
+
+// DirectoryTransferRequest copies or moves a file or folder. A file copied
+// or moved to a path ending in "/" keeps its name.
+type DirectoryTransferRequest struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+}
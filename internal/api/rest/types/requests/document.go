@@ -0,0 +1,17 @@
+package requests
+
+import "encoding/json"
+
+// DocumentUpdateRequest changes a shared document. Fields take the value
+// of their latest write; set elements added concurrently with their
+// removal stay.
+type DocumentUpdateRequest struct {
+	// Set writes fields; values are any JSON
+	Set map[string]json.RawMessage `json:"set,omitempty"`
+	// Delete deletes fields
+	Delete []string `json:"delete,omitempty"`
+	// Add adds elements to sets by set name
+	Add map[string][]string `json:"add,omitempty"`
+	// Remove removes elements from sets by set name
+	Remove map[string][]string `json:"remove,omitempty"`
+}
@@ -1,13 +1,67 @@
 package requests
 
+import "github.com/Skpow1234/Peervault/internal/batch"
+
 // FileUploadRequest represents a file upload request
 type FileUploadRequest struct {
 	Name        string            `json:"name"`
 	ContentType string            `json:"content_type"`
 	Metadata    map[string]string `json:"metadata,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
 }
 
 // FileMetadataUpdateRequest represents a file metadata update request
 type FileMetadataUpdateRequest struct {
 	Metadata map[string]string `json:"metadata"`
 }
+
+// FileMetadataPatchRequest represents a partial update of file tags and metadata
+type FileMetadataPatchRequest struct {
+	Set        map[string]string `json:"set,omitempty"`
+	Remove     []string          `json:"remove,omitempty"`
+	AddTags    []string          `json:"add_tags,omitempty"`
+	RemoveTags []string          `json:"remove_tags,omitempty"`
+}
+
+// FileCopyRequest copies or moves one file to another key on the server.
+// Tenant moves the copy to another tenant, which the node's policy may
+// deny; Metadata entries are set on the copy.
+type FileCopyRequest struct {
+	From      string            `json:"from"`
+	To        string            `json:"to"`
+	Tenant    string            `json:"tenant,omitempty"`
+	Overwrite bool              `json:"overwrite,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// MaxComposeSources is the most files one compose request concatenates
+const MaxComposeSources = 32
+
+// FileComposeRequest builds a file from existing files, concatenated in
+// the order given; a key may be listed more than once. ContentType
+// defaults to that of the first source.
+type FileComposeRequest struct {
+	Sources       []string          `json:"sources"`
+	To            string            `json:"to"`
+	ContentType   string            `json:"content_type,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	Overwrite     bool              `json:"overwrite,omitempty"`
+	DeleteSources bool              `json:"delete_sources,omitempty"`
+}
+
+// ShareLinkCreateRequest represents a request for a signed share link
+type ShareLinkCreateRequest struct {
+	Key          string `json:"key"`
+	ExpiresIn    string `json:"expires_in,omitempty"` // e.g. "12h" or "7d"
+	MaxDownloads int    `json:"max_downloads,omitempty"`
+	Password     string `json:"password,omitempty"`
+}
+
+// BatchRequest runs mixed file operations in one request. Parallelism
+// bounds how many run at once, batch.DefaultParallelism when zero; a
+// parallelism of 1 runs them in the order given.
+type BatchRequest struct {
+	Operations  []batch.Operation `json:"operations"`
+	Parallelism int               `json:"parallelism,omitempty"`
+}
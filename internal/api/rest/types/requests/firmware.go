@@ -0,0 +1,28 @@
+package requests
+
+import "github.com/Skpow1234/Peervault/internal/firmware"
+
+// FirmwareRolloutRequest represents a rollout of a firmware image
+type FirmwareRolloutRequest struct {
+	ImageID string `json:"image_id"`
+	// Rings are released one after the other, the first at once
+	Rings []firmware.Ring `json:"rings"`
+	// FailureThreshold pauses the rollout once that percentage of the
+	// devices done installing failed; 0 never pauses it
+	FailureThreshold int    `json:"failure_threshold,omitempty"`
+	Comment          string `json:"comment,omitempty"`
+}
+
+// FirmwarePauseRequest represents pausing a rollout
+type FirmwarePauseRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// FirmwareStatusRequest represents a device reporting how installing an
+// image goes
+type FirmwareStatusRequest struct {
+	ImageID string `json:"image_id"`
+	// State is downloading, installing, installed or failed
+	State  firmware.InstallState `json:"state"`
+	Detail string                `json:"detail,omitempty"`
+}
@@ -0,0 +1,13 @@
+package requests
+
+// GrafanaSearchRequest is the body of a Grafana metric search. A target
+// naming a label (node, key, tenant or peer) searches its values instead,
+// for template variables.
+type GrafanaSearchRequest struct {
+	Target string `json:"target"`
+}
+
+// GrafanaTagValuesRequest asks for the values of an ad hoc filter label
+type GrafanaTagValuesRequest struct {
+	Key string `json:"key"`
+}
@@ -0,0 +1,12 @@
+package requests
+
+// JobRequest changes how a job of the node's scheduler runs; omitted fields
+// are left as they are
+type JobRequest struct {
+	// Schedule is a cron expression, such as "30 2 * * *", "@daily" or
+	// "@every 6h"; empty restores the job's default schedule
+	Schedule *string `json:"schedule,omitempty"`
+	// Paused stops the scheduled runs of the job; it still runs when
+	// triggered
+	Paused *bool `json:"paused,omitempty"`
+}
@@ -0,0 +1,7 @@
+package requests
+
+// LeaseAcquireRequest acquires a named lease, or renews it for its holder
+type LeaseAcquireRequest struct {
+	Holder     string `json:"holder"`
+	TTLSeconds int64  `json:"ttl_seconds"`
+}
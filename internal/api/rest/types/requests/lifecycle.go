@@ -0,0 +1,8 @@
+package requests
+
+import "github.com/Skpow1234/Peervault/internal/lifecycle"
+
+// LifecyclePolicyRequest replaces the lifecycle policy
+type LifecyclePolicyRequest struct {
+	Rules []lifecycle.Rule `json:"rules"`
+}
@@ -0,0 +1,27 @@
+package requests
+
+// LwM2MWriteRequest represents a write of an LwM2M resource
+type LwM2MWriteRequest struct {
+	// Value is a string, number or boolean; opaque resources take base64
+	// strings
+	Value any `json:"value"`
+}
+
+// LwM2MExecuteRequest represents the execution of an LwM2M resource
+type LwM2MExecuteRequest struct {
+	// Arguments are passed to the resource as they are, such as 0='on'
+	Arguments string `json:"arguments,omitempty"`
+}
+
+// LwM2MObserveRequest represents a request to observe an LwM2M path
+type LwM2MObserveRequest struct {
+	// Path is an object, object instance, resource or resource instance,
+	// such as /3/0/9
+	Path string `json:"path"`
+}
+
+// LwM2MFirmwareRequest represents a firmware update of an LwM2M device
+type LwM2MFirmwareRequest struct {
+	// PackageURI is where the device downloads the firmware from
+	PackageURI string `json:"package_uri"`
+}
@@ -0,0 +1,18 @@
+package requests
+
+// MQTTACLRuleRequest represents a request to add an MQTT topic ACL rule. A
+// rule applies to the clients matching every criterion it sets.
+type MQTTACLRuleRequest struct {
+	// Action is allow or deny
+	Action string `json:"action"`
+	// Access is publish or subscribe; empty applies to both
+	Access string `json:"access,omitempty"`
+	// Topic is an MQTT topic filter, with + and # wildcards and the
+	// {device} and {tenant} placeholders, such as devices/{device}/#
+	Topic string `json:"topic"`
+	// Device matches the device ID of the client's certificate
+	Device string `json:"device,omitempty"`
+	// Tenant matches the tenant of the client's certificate
+	Tenant  string `json:"tenant,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
@@ -6,3 +6,28 @@ type PeerAddRequest struct {
 	Port     int               `json:"port"`
 	Metadata map[string]string `json:"metadata,omitempty"`
 }
+
+// PeerACLRuleRequest represents a request to add a peer ACL rule. A rule
+// matches the peers meeting every criterion it sets.
+type PeerACLRuleRequest struct {
+	// Action is allow or deny
+	Action string `json:"action"`
+	// NodeID matches the node ID the peer authenticated as
+	NodeID string `json:"node_id,omitempty"`
+	// CIDR matches the peer's address, such as 10.0.0.0/8 or a single IP
+	CIDR string `json:"cidr,omitempty"`
+	// Identity matches peers that proved an identity key, "verified", or
+	// that did not, "none"
+	Identity string `json:"identity,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// JoinTokenRequest represents a request to create a join token
+type JoinTokenRequest struct {
+	// TTL is how long the token is valid, e.g. 1h; the configured TTL
+	// when empty
+	TTL string `json:"ttl,omitempty"`
+	// MaxUses is how many nodes may join with the token; 0 is unlimited
+	MaxUses int    `json:"max_uses,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
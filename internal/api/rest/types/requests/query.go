@@ -0,0 +1,8 @@
+package requests
+
+// QueryRequest runs a read-only SQL query over the metadata index
+type QueryRequest struct {
+	SQL string `json:"sql"`
+	// MaxRows bounds the rows returned; zero uses the server default
+	MaxRows int `json:"max_rows,omitempty"`
+}
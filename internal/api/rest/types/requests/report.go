@@ -0,0 +1,31 @@
+package requests
+
+import "github.com/Skpow1234/Peervault/internal/analytics"
+
+// ReportRequest defines a report on the access rollups, named by the path.
+// Empty fields take their defaults: the key dimension, every metric,
+// bucket grouping, a 24h range and CSV output under reports/.
+type ReportRequest struct {
+	Dimension analytics.Dimension `json:"dimension,omitempty"`
+	// Value restricts the report to one key, tenant or peer
+	Value string `json:"value,omitempty"`
+	// Metrics are any of reads, writes, deletes, bytes_read, bytes_written
+	// and total
+	Metrics []analytics.Metric `json:"metrics,omitempty"`
+	// Grouping is bucket, one row per rollup, or total, one row per value
+	Grouping analytics.Grouping `json:"grouping,omitempty"`
+	// Range is how far back the report looks, e.g. 168h
+	Range string `json:"range,omitempty"`
+	// Limit bounds the rows of a report grouped by total
+	Limit int `json:"limit,omitempty"`
+	// Schedule is a cron expression; empty runs the report on demand only
+	Schedule string `json:"schedule,omitempty"`
+	// Format is csv, json or pdf
+	Format analytics.Format `json:"format,omitempty"`
+	// Prefix is the key prefix the output is stored under
+	Prefix string `json:"prefix,omitempty"`
+	// Webhook receives the output in a POST
+	Webhook string `json:"webhook,omitempty"`
+	// Email lists the addresses the output is mailed to
+	Email []string `json:"email,omitempty"`
+}
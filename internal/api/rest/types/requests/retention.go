@@ -0,0 +1,8 @@
+package requests
+
+// FileLockUpdateRequest changes the lock of a file. Omitted fields are left
+// unchanged.
+type FileLockUpdateRequest struct {
+	RetainUntil string `json:"retain_until,omitempty"` // RFC 3339 time or a period such as "30d"
+	LegalHold   *bool  `json:"legal_hold,omitempty"`
+}
@@ -0,0 +1,22 @@
+package requests
+
+// SnapshotCreateRequest selects the namespace (key prefix) to snapshot; an
+// empty namespace snapshots every file
+type SnapshotCreateRequest struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name,omitempty"`
+}
+
+// SnapshotCloneRequest writes the files of a snapshot under Prefix in place
+// of its namespace. Cloning onto the namespace itself rolls it back.
+type SnapshotCloneRequest struct {
+	Prefix    string `json:"prefix"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+}
+
+// SnapshotBackupRequest runs a backup job from a snapshot
+type SnapshotBackupRequest struct {
+	Job string `json:"job"`
+	// Type is full or incremental (the default)
+	Type string `json:"type,omitempty"`
+}
@@ -0,0 +1,11 @@
+package requests
+
+// UploadCreateRequest starts a resumable upload of a file sent in parts
+type UploadCreateRequest struct {
+	Name        string            `json:"name"`
+	ContentType string            `json:"content_type,omitempty"`
+	Size        int64             `json:"size"`
+	PartSize    int64             `json:"part_size,omitempty"` // bytes per part; the server default when omitted
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+}
@@ -0,0 +1,33 @@
+package responses
+
+import "github.com/Skpow1234/Peervault/internal/alerting"
+
+// AlertListResponse represents the state of every alert rule
+type AlertListResponse struct {
+	Alerts []alerting.Alert `json:"alerts"`
+	Total  int              `json:"total"`
+}
+
+// AlertRuleListResponse represents the alert rules of a node
+type AlertRuleListResponse struct {
+	Rules []alerting.Rule `json:"rules"`
+	Total int             `json:"total"`
+}
+
+// AlertChannelListResponse represents the notification channels of a node
+type AlertChannelListResponse struct {
+	Channels []alerting.Channel `json:"channels"`
+	Total    int                `json:"total"`
+}
+
+// SilenceListResponse represents the silences that have not ended
+type SilenceListResponse struct {
+	Silences []alerting.Silence `json:"silences"`
+	Total    int                `json:"total"`
+}
+
+// MetricListResponse represents the latest value of every metric observed
+type MetricListResponse struct {
+	Metrics []alerting.Sample `json:"metrics"`
+	Total   int               `json:"total"`
+}
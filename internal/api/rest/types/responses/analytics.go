@@ -0,0 +1,22 @@
+package responses
+
+import "github.com/Skpow1234/Peervault/internal/analytics"
+
+// AccessRollupsResponse represents the access rollups of a node
+type AccessRollupsResponse struct {
+	Dimension     analytics.Dimension `json:"dimension"`
+	BucketSeconds int64               `json:"bucket_seconds"`
+	Rollups       []analytics.Rollup  `json:"rollups"`
+}
+
+// AccessTopResponse represents the most accessed keys, tenants or peers
+type AccessTopResponse struct {
+	Dimension analytics.Dimension `json:"dimension"`
+	Totals    []analytics.Total   `json:"totals"`
+}
+
+// ReportListResponse represents the analytics reports of a node
+type ReportListResponse struct {
+	Reports []analytics.ReportInfo `json:"reports"`
+	Total   int                    `json:"total"`
+}
@@ -0,0 +1,22 @@
+package responses
+
+import "github.com/Skpow1234/Peervault/internal/backup"
+
+// BackupJobListResponse represents the configured backup jobs
+type BackupJobListResponse struct {
+	Jobs  []backup.JobStatus `json:"jobs"`
+	Total int                `json:"total"`
+}
+
+// BackupRunResponse acknowledges a backup started in the background
+type BackupRunResponse struct {
+	Job    string            `json:"job"`
+	Type   backup.BackupType `json:"type"`
+	Status string            `json:"status"`
+}
+
+// BackupSnapshotListResponse represents a job's snapshots, oldest first
+type BackupSnapshotListResponse struct {
+	Snapshots []backup.Snapshot `json:"snapshots"`
+	Total     int               `json:"total"`
+}
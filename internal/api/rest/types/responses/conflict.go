@@ -0,0 +1,9 @@
+package responses
+
+import "github.com/Skpow1234/Peervault/internal/app/fileserver"
+
+// ConflictListResponse represents the files with conflicting versions
+type ConflictListResponse struct {
+	Conflicts []fileserver.FileVersions `json:"conflicts"`
+	Total     int                       `json:"total"`
+}
@@ -0,0 +1,17 @@
+package responses
+
+import "github.com/Skpow1234/Peervault/internal/devices"
+
+// DeviceSecretResponse represents a registered device with its secret.
+// Secret is only ever returned here: it is what the device is flashed
+// with to enroll.
+type DeviceSecretResponse struct {
+	devices.Device
+	Secret string `json:"secret"`
+}
+
+// DeviceListResponse represents the devices of the device CA
+type DeviceListResponse struct {
+	Devices []devices.Device `json:"devices"`
+	Total   int              `json:"total"`
+}
@@ -0,0 +1,7 @@
+package responses
+
+// DocumentListResponse represents the shared documents
+type DocumentListResponse struct {
+	Documents []string `json:"documents"`
+	Total     int      `json:"total"`
+}
@@ -0,0 +1,27 @@
+package responses
+
+import "time"
+
+// ExportResponse represents an export with where it writes and the
+// outcome of its last run
+type ExportResponse struct {
+	Name     string `json:"name"`
+	Dataset  string `json:"dataset"`
+	Format   string `json:"format"`
+	Schedule string `json:"schedule,omitempty"`
+	// Range is how far back exports of accesses and rollups look
+	Range       string    `json:"range,omitempty"`
+	Dimension   string    `json:"dimension,omitempty"`
+	Prefix      string    `json:"prefix"`
+	Destination string    `json:"destination"`
+	LastRun     time.Time `json:"last_run,omitempty"`
+	LastKey     string    `json:"last_key,omitempty"`
+	LastRows    int       `json:"last_rows,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// ExportListResponse represents the exports of the node
+type ExportListResponse struct {
+	Exports []ExportResponse `json:"exports"`
+	Total   int              `json:"total"`
+}
@@ -1,6 +1,10 @@
 package responses
 
-import "time"
+import (
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/metadata"
+)
 
 // FileResponse represents a file response
 type FileResponse struct {
@@ -9,9 +13,11 @@ type FileResponse struct {
 	Size        int64                 `json:"size"`
 	ContentType string                `json:"content_type"`
 	Hash        string                `json:"hash"`
+	MerkleRoot  string                `json:"merkle_root,omitempty"`
 	CreatedAt   time.Time             `json:"created_at"`
 	UpdatedAt   time.Time             `json:"updated_at"`
 	Metadata    map[string]string     `json:"metadata,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
 	Replicas    []FileReplicaResponse `json:"replicas,omitempty"`
 }
 
@@ -27,3 +33,33 @@ type FileListResponse struct {
 	Files []FileResponse `json:"files"`
 	Total int            `json:"total"`
 }
+
+// FileUsageResponse represents the usage under a prefix, first, and of the
+// directories below it
+type FileUsageResponse struct {
+	Prefixes []metadata.PrefixUsage `json:"prefixes"`
+}
+
+// TenantUsageResponse represents the usage of every tenant
+type TenantUsageResponse struct {
+	Tenants []metadata.TenantUsage `json:"tenants"`
+}
+
+// BatchResultResponse is the result of one operation of a batch. Status is
+// the HTTP status the operation would have had on its own endpoint.
+type BatchResultResponse struct {
+	Index  int           `json:"index"`
+	Op     string        `json:"op"`
+	Key    string        `json:"key"`
+	Status int           `json:"status"`
+	Error  string        `json:"error,omitempty"`
+	File   *FileResponse `json:"file,omitempty"`
+}
+
+// BatchResponse represents the results of a batch, in the order of its
+// operations
+type BatchResponse struct {
+	Results   []BatchResultResponse `json:"results"`
+	Succeeded int                   `json:"succeeded"`
+	Failed    int                   `json:"failed"`
+}
@@ -0,0 +1,22 @@
+package responses
+
+import "github.com/Skpow1234/Peervault/internal/firmware"
+
+// FirmwareImageListResponse represents the firmware images
+type FirmwareImageListResponse struct {
+	Images []firmware.Image `json:"images"`
+	Total  int              `json:"total"`
+}
+
+// FirmwareRolloutListResponse represents the firmware rollouts
+type FirmwareRolloutListResponse struct {
+	Rollouts []firmware.RolloutStatus `json:"rollouts"`
+	Total    int                      `json:"total"`
+}
+
+// FirmwareRolloutResponse represents a rollout and the status of each of
+// its devices
+type FirmwareRolloutResponse struct {
+	Rollout firmware.RolloutStatus  `json:"rollout"`
+	Devices []firmware.DeviceStatus `json:"devices"`
+}
@@ -0,0 +1,18 @@
+package responses
+
+// GrafanaMetric is a metric offered by the datasource's query editor
+type GrafanaMetric struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// GrafanaTagKey is a label ad hoc filters can use
+type GrafanaTagKey struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// GrafanaTagValue is a value of an ad hoc filter label
+type GrafanaTagValue struct {
+	Text string `json:"text"`
+}
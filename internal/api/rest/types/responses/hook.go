@@ -0,0 +1,9 @@
+package responses
+
+import "github.com/Skpow1234/Peervault/internal/hooks"
+
+// HookListResponse represents the enabled hooks and their counters
+type HookListResponse struct {
+	Hooks []hooks.Stats `json:"hooks"`
+	Total int           `json:"total"`
+}
@@ -0,0 +1,15 @@
+package responses
+
+import "github.com/Skpow1234/Peervault/internal/scheduler"
+
+// JobListResponse represents the jobs of the node's scheduler
+type JobListResponse struct {
+	Jobs  []scheduler.JobStatus `json:"jobs"`
+	Total int                   `json:"total"`
+}
+
+// JobResponse represents a job with its recent runs, newest first
+type JobResponse struct {
+	scheduler.JobStatus
+	Runs []scheduler.Run `json:"runs"`
+}
@@ -0,0 +1,9 @@
+package responses
+
+import "github.com/Skpow1234/Peervault/internal/consensus"
+
+// LeaseListResponse represents the leases in force
+type LeaseListResponse struct {
+	Leases []consensus.Lease `json:"leases"`
+	Total  int               `json:"total"`
+}
@@ -0,0 +1,10 @@
+package responses
+
+import "github.com/Skpow1234/Peervault/internal/lifecycle"
+
+// LifecyclePolicyResponse represents the active lifecycle policy
+type LifecyclePolicyResponse struct {
+	Rules []lifecycle.Rule `json:"rules"`
+	// Enforcing is false while scheduled runs only report
+	Enforcing bool `json:"enforcing"`
+}
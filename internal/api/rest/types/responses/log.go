@@ -0,0 +1,16 @@
+package responses
+
+import "github.com/Skpow1234/Peervault/internal/streamlog"
+
+// LogListResponse represents the append-only logs
+type LogListResponse struct {
+	Logs  []streamlog.Info `json:"logs"`
+	Total int              `json:"total"`
+}
+
+// LogRecordsResponse represents records read from a log, and the offset to
+// read from next
+type LogRecordsResponse struct {
+	Records    []streamlog.Record `json:"records"`
+	NextOffset uint64             `json:"next_offset"`
+}
@@ -0,0 +1,21 @@
+package responses
+
+import "github.com/Skpow1234/Peervault/internal/lwm2m"
+
+// LwM2MClientListResponse represents the devices registered with the
+// LwM2M server
+type LwM2MClientListResponse struct {
+	Clients []lwm2m.Registration `json:"clients"`
+	Total   int                  `json:"total"`
+}
+
+// LwM2MValuesResponse represents the values read or observed at a path
+type LwM2MValuesResponse struct {
+	Path   string        `json:"path"`
+	Values []lwm2m.Value `json:"values"`
+}
+
+// LwM2MObjectListResponse represents the object model of the LwM2M server
+type LwM2MObjectListResponse struct {
+	Objects []lwm2m.ObjectDef `json:"objects"`
+}
@@ -0,0 +1,9 @@
+package responses
+
+import "github.com/Skpow1234/Peervault/internal/api/mqtt/topicacl"
+
+// MQTTACLRuleListResponse represents the MQTT topic ACL rules of a node
+type MQTTACLRuleListResponse struct {
+	Rules []topicacl.Rule `json:"rules"`
+	Total int             `json:"total"`
+}
@@ -1,6 +1,11 @@
 package responses
 
-import "time"
+import (
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/acl"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/join"
+)
 
 // PeerResponse represents a peer response
 type PeerResponse struct {
@@ -18,3 +23,22 @@ type PeerListResponse struct {
 	Peers []PeerResponse `json:"peers"`
 	Total int            `json:"total"`
 }
+
+// PeerACLRuleListResponse represents the peer ACL rules of a node
+type PeerACLRuleListResponse struct {
+	Rules []acl.Rule `json:"rules"`
+	Total int        `json:"total"`
+}
+
+// JoinTokenResponse represents a created join token. Token is only ever
+// returned here: it is what the new node is configured with.
+type JoinTokenResponse struct {
+	join.Record
+	Token string `json:"token"`
+}
+
+// JoinTokenListResponse represents the join tokens of a node
+type JoinTokenListResponse struct {
+	Tokens []join.Record `json:"tokens"`
+	Total  int           `json:"total"`
+}
@@ -0,0 +1,16 @@
+package responses
+
+import "github.com/Skpow1234/Peervault/internal/policy"
+
+// PolicyResponse represents the rules the node evaluates
+type PolicyResponse struct {
+	Rules []policy.Rule `json:"rules"`
+	// DryRun is true while denials are only logged
+	DryRun bool `json:"dry_run"`
+}
+
+// PolicyDecisionListResponse represents recent policy decisions, newest first
+type PolicyDecisionListResponse struct {
+	Decisions []policy.Decision `json:"decisions"`
+	Total     int               `json:"total"`
+}
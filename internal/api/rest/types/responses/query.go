@@ -0,0 +1,23 @@
+package responses
+
+// QueryResponse represents the rows of a SQL query
+type QueryResponse struct {
+	Columns []string `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+	Total   int      `json:"total"`
+	// Truncated is set when rows past the limit were dropped
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// QueryTable represents a table SQL queries can select from
+type QueryTable struct {
+	Name        string   `json:"name"`
+	Columns     []string `json:"columns"`
+	Description string   `json:"description"`
+}
+
+// QueryTableListResponse represents the tables SQL queries can select from
+type QueryTableListResponse struct {
+	Tables []QueryTable `json:"tables"`
+	Total  int          `json:"total"`
+}
@@ -0,0 +1,14 @@
+package responses
+
+import "github.com/Skpow1234/Peervault/internal/queue"
+
+// QueueJobListResponse represents jobs of the node's background queue
+type QueueJobListResponse struct {
+	Jobs  []queue.Job `json:"jobs"`
+	Total int         `json:"total"`
+}
+
+// QueueRequeueResponse reports how many dead jobs were requeued
+type QueueRequeueResponse struct {
+	Requeued int `json:"requeued"`
+}
@@ -0,0 +1,19 @@
+package responses
+
+import "time"
+
+// FileLockResponse represents the retention lock of a file
+type FileLockResponse struct {
+	Key         string     `json:"key"`
+	Locked      bool       `json:"locked"`
+	LegalHold   bool       `json:"legal_hold"`
+	RetainUntil *time.Time `json:"retain_until,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+	UpdatedBy   string     `json:"updated_by,omitempty"`
+}
+
+// FileLockListResponse represents the locks in force
+type FileLockListResponse struct {
+	Locks []FileLockResponse `json:"locks"`
+	Total int                `json:"total"`
+}
@@ -0,0 +1,32 @@
+package responses
+
+import "time"
+
+// SearchHitResponse represents a ranked search match
+type SearchHitResponse struct {
+	Key         string   `json:"key"`
+	Name        string   `json:"name"`
+	ContentType string   `json:"content_type,omitempty"`
+	Score       float64  `json:"score"`
+	Highlights  []string `json:"highlights,omitempty"`
+}
+
+// SearchResponse represents a page of search results
+type SearchResponse struct {
+	Query   string              `json:"query"`
+	Total   int                 `json:"total"`
+	Limit   int                 `json:"limit"`
+	Offset  int                 `json:"offset"`
+	TookMs  float64             `json:"took_ms"`
+	Results []SearchHitResponse `json:"results"`
+}
+
+// SearchStatusResponse represents the state of the search index
+type SearchStatusResponse struct {
+	Documents   int        `json:"documents"`
+	Terms       int        `json:"terms"`
+	Rebuilding  bool       `json:"rebuilding"`
+	Persistent  bool       `json:"persistent"`
+	LastRebuild *time.Time `json:"last_rebuild,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
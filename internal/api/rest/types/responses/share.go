@@ -0,0 +1,26 @@
+package responses
+
+import "time"
+
+// ShareLinkResponse represents a signed share link
+type ShareLinkResponse struct {
+	ID                string     `json:"id"`
+	Key               string     `json:"key"`
+	URL               string     `json:"url"`
+	CreatedBy         string     `json:"created_by,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	ExpiresAt         time.Time  `json:"expires_at"`
+	MaxDownloads      int        `json:"max_downloads"`
+	Downloads         int        `json:"downloads"`
+	LastAccessed      *time.Time `json:"last_accessed,omitempty"`
+	PasswordProtected bool       `json:"password_protected"`
+	Revoked           bool       `json:"revoked"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	Active            bool       `json:"active"`
+}
+
+// ShareLinkListResponse represents a list of share links
+type ShareLinkListResponse struct {
+	Links []ShareLinkResponse `json:"links"`
+	Total int                 `json:"total"`
+}
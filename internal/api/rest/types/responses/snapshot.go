@@ -0,0 +1,9 @@
+package responses
+
+import "github.com/Skpow1234/Peervault/internal/snapshot"
+
+// SnapshotListResponse represents the snapshots, oldest first
+type SnapshotListResponse struct {
+	Snapshots []snapshot.Snapshot `json:"snapshots"`
+	Total     int                 `json:"total"`
+}
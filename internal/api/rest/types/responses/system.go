@@ -1,6 +1,10 @@
 package responses
 
-import "time"
+import (
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/cache"
+)
 
 // SystemInfoResponse represents system information response
 type SystemInfoResponse struct {
@@ -20,6 +24,8 @@ type MetricsResponse struct {
 	ActiveConnections int       `json:"active_connections"`
 	StorageUsage      float64   `json:"storage_usage_percent"`
 	LastUpdated       time.Time `json:"last_updated"`
+	// GatewayCache counts the public gateway's cache; absent when it has none
+	GatewayCache *cache.ByteCacheStats `json:"gateway_cache,omitempty"`
 }
 
 // HealthResponse represents a health check response
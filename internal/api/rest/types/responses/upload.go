@@ -0,0 +1,22 @@
+package responses
+
+import "time"
+
+// UploadResponse represents a resumable upload and the parts received so far
+type UploadResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	PartSize  int64     `json:"part_size"`
+	PartCount int       `json:"part_count"`
+	Parts     []int     `json:"parts"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UploadPartResponse acknowledges a stored part
+type UploadPartResponse struct {
+	Part   int    `json:"part"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
@@ -0,0 +1,362 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/directory"
+)
+
+var (
+	// errReadOnly is returned for changes by read-only users
+	errReadOnly = errors.New("sftp: read-only user")
+	// errIsDir is returned for file operations on directories
+	errIsDir = errors.New("sftp: is a directory")
+	// errNotDir is returned for directory operations on files
+	errNotDir = errors.New("sftp: not a directory")
+	// errTooLarge is returned for uploads over MaxFileSize
+	errTooLarge = errors.New("sftp: file too large")
+)
+
+// info is a file or directory of a user's view
+type info struct {
+	name  string
+	dir   bool
+	size  int64
+	mtime time.Time
+}
+
+// view is the store as a user sees it, chrooted to their namespace
+type view struct {
+	server *Server
+	user   *User
+}
+
+// clean returns the absolute form of a path of the user. Relative paths
+// start at "/", the user's namespace, and ".." stops there.
+func clean(p string) string {
+	return path.Clean("/" + p)
+}
+
+// key returns the key of a path of the user
+func (v *view) key(p string) string {
+	return v.user.Namespace + strings.TrimPrefix(clean(p), "/")
+}
+
+// root reports whether key is the user's namespace
+func (v *view) root(key string) bool {
+	return key == v.user.Namespace
+}
+
+// stat returns the file or directory at key. The namespace is a directory
+// even while no key is below it.
+func (v *view) stat(ctx context.Context, key string) (*info, error) {
+	if v.root(key) {
+		return &info{name: "/", dir: true}, nil
+	}
+	objects, err := v.server.store.List(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var dir *info
+	for _, o := range objects {
+		switch {
+		case o.Key == key:
+			return &info{name: directory.Base(key), size: o.Size, mtime: o.ModTime}, nil
+		case strings.HasPrefix(o.Key, key+directory.Separator):
+			if dir == nil {
+				dir = &info{name: directory.Base(key), dir: true}
+			}
+			if o.ModTime.After(dir.mtime) {
+				dir.mtime = o.ModTime
+			}
+		}
+	}
+	if dir == nil {
+		return nil, fmt.Errorf("%w: %s", directory.ErrNotFound, key)
+	}
+	return dir, nil
+}
+
+// list returns the files and directories in the directory at key, sorted
+// by name. A directory's time is that of the newest key below it.
+func (v *view) list(ctx context.Context, key string) ([]*info, error) {
+	dir := key
+	if !v.root(key) {
+		dir += directory.Separator
+	}
+	objects, err := v.server.store.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 && !v.root(key) {
+		if _, err := v.stat(ctx, key); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s", errNotDir, key)
+	}
+
+	children := make(map[string]*info)
+	for _, o := range objects {
+		rest := o.Key[len(dir):]
+		if rest == "" {
+			continue
+		}
+		name, _, isDir := strings.Cut(rest, directory.Separator)
+		c := children[name]
+		if c == nil {
+			c = &info{name: name, dir: isDir}
+			children[name] = c
+		}
+		if !isDir {
+			c.size = o.Size
+		}
+		if o.ModTime.After(c.mtime) {
+			c.mtime = o.ModTime
+		}
+	}
+	entries := make([]*info, 0, len(children))
+	for _, c := range children {
+		entries = append(entries, c)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	return entries, nil
+}
+
+// mode returns the file type and permission bits of fi
+func (v *view) mode(fi *info) uint32 {
+	mode := uint32(0o100644)
+	if fi.dir {
+		mode = 0o40755
+	}
+	if v.user.ReadOnly {
+		mode &^= 0o222
+	}
+	return mode
+}
+
+// child returns the key of name in the directory at key
+func (v *view) child(key, name string) string {
+	if v.root(key) {
+		return key + name
+	}
+	return key + directory.Separator + name
+}
+
+// read returns the file at key and its content
+func (v *view) read(ctx context.Context, key string) (*info, []byte, error) {
+	fi, err := v.stat(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fi.dir {
+		return nil, nil, fmt.Errorf("%w: %s", errIsDir, key)
+	}
+	data, err := v.server.store.Read(ctx, key)
+	return fi, data, err
+}
+
+// create opens an upload of the file at key, starting from its content
+// unless truncate is set. A directory is never replaced; with exclusive
+// neither is a file.
+func (v *view) create(ctx context.Context, key string, truncate, exclusive bool) (*upload, error) {
+	if v.user.ReadOnly {
+		return nil, errReadOnly
+	}
+	fi, err := v.stat(ctx, key)
+	switch {
+	case errors.Is(err, directory.ErrNotFound):
+		truncate = true
+	case err != nil:
+		return nil, err
+	case fi.dir:
+		return nil, fmt.Errorf("%w: %s", errIsDir, key)
+	case exclusive:
+		return nil, fmt.Errorf("%w: %s", directory.ErrExists, key)
+	}
+	if strings.HasSuffix(key, directory.Separator) || v.root(key) {
+		return nil, fmt.Errorf("%w: %s", directory.ErrInvalidPath, key)
+	}
+
+	spool, err := os.CreateTemp(v.server.config.SpoolDir, "peervault-sftp-*")
+	if err != nil {
+		return nil, err
+	}
+	u := &upload{view: v, key: key, spool: spool, dirty: truncate}
+	if !truncate {
+		data, err := v.server.store.Read(ctx, key)
+		if err == nil {
+			_, err = spool.Write(data)
+		}
+		if err != nil {
+			u.discard()
+			return nil, err
+		}
+		u.size = int64(len(data))
+	}
+	return u, nil
+}
+
+// upload is a file being written, spooled until it is closed
+type upload struct {
+	view  *view
+	key   string
+	spool *os.File
+	size  int64
+	// dirty is set once the file differs from the stored one
+	dirty bool
+}
+
+// WriteAt writes p at offset, within MaxFileSize
+func (u *upload) WriteAt(p []byte, offset int64) (int, error) {
+	if end := offset + int64(len(p)); end > u.view.server.config.MaxFileSize {
+		return 0, fmt.Errorf("%w: over %d bytes", errTooLarge, u.view.server.config.MaxFileSize)
+	}
+	n, err := u.spool.WriteAt(p, offset)
+	u.size = max(u.size, offset+int64(n))
+	u.dirty = true
+	return n, err
+}
+
+// ReadAt reads what was written so far
+func (u *upload) ReadAt(p []byte, offset int64) (int, error) {
+	return u.spool.ReadAt(p, offset)
+}
+
+// Truncate sets the size of the file
+func (u *upload) Truncate(size int64) error {
+	if size > u.view.server.config.MaxFileSize {
+		return fmt.Errorf("%w: over %d bytes", errTooLarge, u.view.server.config.MaxFileSize)
+	}
+	if err := u.spool.Truncate(size); err != nil {
+		return err
+	}
+	u.size = size
+	u.dirty = true
+	return nil
+}
+
+// store stores the file if it changed and drops the spool
+func (u *upload) store(ctx context.Context) error {
+	defer u.discard()
+	if !u.dirty {
+		return nil
+	}
+	if _, err := u.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return u.view.server.store.Write(ctx, u.key, io.LimitReader(u.spool, u.size), u.view.user.Tenant)
+}
+
+// discard drops the spool without storing the file
+func (u *upload) discard() {
+	u.spool.Close()
+	os.Remove(u.spool.Name())
+}
+
+// write stores size bytes read from r as the file at key
+func (v *view) write(ctx context.Context, key string, r io.Reader, size int64) error {
+	if size > v.server.config.MaxFileSize {
+		return fmt.Errorf("%w: over %d bytes", errTooLarge, v.server.config.MaxFileSize)
+	}
+	u, err := v.create(ctx, key, true, false)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(u.spool, r, size); err != nil {
+		u.discard()
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	u.size = size
+	return u.store(ctx)
+}
+
+// truncate sets the size of the file at key
+func (v *view) truncate(ctx context.Context, key string, size int64) error {
+	u, err := v.create(ctx, key, false, false)
+	if err != nil {
+		return err
+	}
+	if err := u.Truncate(size); err != nil {
+		u.discard()
+		return err
+	}
+	return u.store(ctx)
+}
+
+// mkdir creates the directory at key
+func (v *view) mkdir(ctx context.Context, key string) error {
+	if v.user.ReadOnly {
+		return errReadOnly
+	}
+	if _, err := v.stat(ctx, key); err == nil {
+		return fmt.Errorf("%w: %s", directory.ErrExists, key)
+	}
+	_, err := v.server.tree.Mkdir(ctx, key+directory.Separator)
+	return err
+}
+
+// remove deletes the file at key, or the empty directory with dir
+func (v *view) remove(ctx context.Context, key string, dir bool) error {
+	if v.user.ReadOnly {
+		return errReadOnly
+	}
+	if v.root(key) {
+		return fmt.Errorf("%w: cannot remove the root", directory.ErrInvalidPath)
+	}
+	fi, err := v.stat(ctx, key)
+	switch {
+	case err != nil:
+		return err
+	case fi.dir && !dir:
+		return fmt.Errorf("%w: %s", errIsDir, key)
+	case !fi.dir && dir:
+		return fmt.Errorf("%w: %s", errNotDir, key)
+	case dir:
+		key += directory.Separator
+	}
+	report, err := v.server.tree.Delete(ctx, key, false)
+	if err == nil && report.Failed > 0 {
+		err = errors.New(report.Errors[0])
+	}
+	return err
+}
+
+// rename moves the file or directory at from to to. An existing file is
+// only replaced with overwrite, an existing directory never.
+func (v *view) rename(ctx context.Context, from, to string, overwrite bool) error {
+	if v.user.ReadOnly {
+		return errReadOnly
+	}
+	if v.root(from) || v.root(to) {
+		return fmt.Errorf("%w: cannot move the root", directory.ErrInvalidPath)
+	}
+	src, err := v.stat(ctx, from)
+	if err != nil {
+		return err
+	}
+	if from == to {
+		return nil
+	}
+	if dst, err := v.stat(ctx, to); err == nil && (!overwrite || dst.dir || src.dir) {
+		return fmt.Errorf("%w: %s", directory.ErrExists, to)
+	}
+	if src.dir {
+		from, to = from+directory.Separator, to+directory.Separator
+	}
+	report, err := v.server.tree.Move(ctx, from, to, overwrite)
+	if err == nil && report.Failed > 0 {
+		err = errors.New(report.Errors[0])
+	}
+	return err
+}
@@ -0,0 +1,344 @@
+package sftp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// scp runs over a session as the command "scp -t target", receiving
+// files, or "scp -f path...", sending them. Each message is a line ("C"
+// for a file, followed by its content, "D" and "E" around a directory,
+// "T" for times) and is acknowledged with a zero byte, or a line starting
+// with 1 (warning) or 2 (fatal error).
+
+// scpCommand is an scp command run by a client
+type scpCommand struct {
+	// sink is set for -t, receiving files; otherwise -f sends them
+	sink      bool
+	recursive bool
+	// times sends the modification times of files with -p
+	times bool
+	// dirTarget requires the target to be a directory (-d)
+	dirTarget bool
+	paths     []string
+}
+
+// errProtocol is returned for scp messages that cannot be understood
+var errProtocol = errors.New("scp: protocol error")
+
+// parseSCP parses the command a client runs
+func parseSCP(command string) (*scpCommand, error) {
+	args, err := splitCommand(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 || args[0] != "scp" {
+		return nil, errors.New("only SFTP and scp are served")
+	}
+
+	cmd := &scpCommand{}
+	source := false
+	i := 1
+	for ; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			i++
+			break
+		}
+		if !strings.HasPrefix(a, "-") || a == "-" {
+			break
+		}
+		for _, flag := range a[1:] {
+			switch flag {
+			case 't':
+				cmd.sink = true
+			case 'f':
+				source = true
+			case 'r':
+				cmd.recursive = true
+			case 'p':
+				cmd.times = true
+			case 'd':
+				cmd.dirTarget = true
+			case 'v', 'q':
+			default:
+				return nil, fmt.Errorf("scp: unsupported option -%c", flag)
+			}
+		}
+	}
+	cmd.paths = args[i:]
+	switch {
+	case cmd.sink == source:
+		return nil, errors.New("scp: one of -t and -f is required")
+	case len(cmd.paths) == 0:
+		return nil, errors.New("scp: no path given")
+	case cmd.sink && len(cmd.paths) > 1:
+		return nil, errors.New("scp: one target is allowed")
+	}
+	return cmd, nil
+}
+
+// splitCommand splits a command line into words the way a shell does,
+// honoring quotes and backslashes
+func splitCommand(command string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		case c == '\\' && i+1 < len(command):
+			i++
+			word.WriteByte(command[i])
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(command[i+1:], c)
+			if end < 0 {
+				return nil, errors.New("scp: unterminated quote")
+			}
+			quoted := command[i+1 : i+1+end]
+			if c == '"' {
+				quoted = strings.NewReplacer(`\"`, `"`, `\\`, `\`, `\$`, `$`, "\\`", "`").Replace(quoted)
+			}
+			word.WriteString(quoted)
+			i += end + 1
+		default:
+			word.WriteByte(c)
+		}
+		inWord = true
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// serveSCP runs an scp command on rw
+func (s *Server) serveSCP(ctx context.Context, u *User, rw io.ReadWriter, cmd *scpCommand) error {
+	v := &view{server: s, user: u}
+	r := bufio.NewReader(rw)
+	if cmd.sink {
+		return v.scpSink(ctx, r, rw, cmd)
+	}
+	return v.scpSource(ctx, r, rw, cmd)
+}
+
+// scpAck reads the reply to a message
+func scpAck(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch b {
+	case 0:
+		return nil
+	case 1, 2:
+		line, _ := r.ReadString('\n')
+		return fmt.Errorf("scp: client: %s", strings.TrimSpace(line))
+	default:
+		return errProtocol
+	}
+}
+
+// scpError reports an error to the client, a warning unless fatal
+func scpError(w io.Writer, fatal bool, err error) {
+	code := byte(1)
+	if fatal {
+		code = 2
+	}
+	fmt.Fprintf(w, "%cscp: %s\n", code, strings.ReplaceAll(err.Error(), "\n", " "))
+}
+
+// scpSink receives files into the target, a file or a directory
+func (v *view) scpSink(ctx context.Context, r *bufio.Reader, w io.Writer, cmd *scpCommand) error {
+	target := v.key(cmd.paths[0])
+	if v.user.ReadOnly {
+		scpError(w, true, errReadOnly)
+		return errReadOnly
+	}
+	fi, err := v.stat(ctx, target)
+	targetDir := err == nil && fi.dir
+	if cmd.dirTarget && !targetDir {
+		err := fmt.Errorf("%w: %s", errNotDir, cmd.paths[0])
+		scpError(w, true, err)
+		return err
+	}
+	w.Write([]byte{0})
+
+	// dirs are the directories being received, innermost last
+	var dirs []string
+	var failed error
+	for {
+		line, err := r.ReadString('\n')
+		if errors.Is(err, io.EOF) && line == "" {
+			return failed
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			scpError(w, true, errProtocol)
+			return errProtocol
+		}
+
+		switch line[0] {
+		case 'T':
+			w.Write([]byte{0})
+			continue
+		case 'E':
+			if len(dirs) == 0 {
+				scpError(w, true, errProtocol)
+				return errProtocol
+			}
+			dirs = dirs[:len(dirs)-1]
+			w.Write([]byte{0})
+			continue
+		case 1, 2:
+			return fmt.Errorf("scp: client: %s", line[1:])
+		case 'C', 'D':
+		default:
+			scpError(w, true, errProtocol)
+			return errProtocol
+		}
+
+		fields := strings.SplitN(line[1:], " ", 3)
+		if len(fields) != 3 || fields[2] == "" || fields[2] == "." || fields[2] == ".." || strings.Contains(fields[2], "/") {
+			scpError(w, true, errProtocol)
+			return errProtocol
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || size < 0 {
+			scpError(w, true, errProtocol)
+			return errProtocol
+		}
+		dest := target
+		switch {
+		case len(dirs) > 0:
+			dest = v.child(dirs[len(dirs)-1], fields[2])
+		case targetDir:
+			dest = v.child(target, fields[2])
+		}
+
+		if line[0] == 'D' {
+			if !cmd.recursive {
+				scpError(w, true, errors.New("received a directory without -r"))
+				return errProtocol
+			}
+			if fi, err := v.stat(ctx, dest); err != nil {
+				err = v.mkdir(ctx, dest)
+			} else if !fi.dir {
+				err = fmt.Errorf("%w: %s", errNotDir, fields[2])
+			}
+			if err != nil {
+				scpError(w, true, err)
+				return err
+			}
+			dirs = append(dirs, dest)
+			w.Write([]byte{0})
+			continue
+		}
+
+		w.Write([]byte{0})
+		content := &io.LimitedReader{R: r, N: size}
+		err = v.write(ctx, dest, content, size)
+		if _, drain := io.Copy(io.Discard, content); drain != nil {
+			return drain
+		}
+		if err := scpAck(r); err != nil {
+			return err
+		}
+		if err != nil {
+			failed = err
+			scpError(w, false, fmt.Errorf("%s: %w", fields[2], err))
+			continue
+		}
+		w.Write([]byte{0})
+	}
+}
+
+// scpSource sends the files and directories at the paths
+func (v *view) scpSource(ctx context.Context, r *bufio.Reader, w io.Writer, cmd *scpCommand) error {
+	if err := scpAck(r); err != nil {
+		return err
+	}
+	var failed error
+	for _, p := range cmd.paths {
+		name := path.Base(clean(p))
+		if name == "/" {
+			name = v.user.Tenant
+		}
+		if err := v.scpSend(ctx, r, w, cmd, v.key(p), name, &failed); err != nil {
+			return err
+		}
+	}
+	return failed
+}
+
+// scpSend sends the file or directory at key as name. Files that cannot
+// be sent are reported to the client and in failed; the error returned
+// ends the transfer.
+func (v *view) scpSend(ctx context.Context, r *bufio.Reader, w io.Writer, cmd *scpCommand, key, name string, failed *error) error {
+	warn := func(err error) error {
+		*failed = err
+		scpError(w, false, fmt.Errorf("%s: %w", name, err))
+		return nil
+	}
+	fi, err := v.stat(ctx, key)
+	if err != nil {
+		return warn(err)
+	}
+	if fi.dir && !cmd.recursive {
+		return warn(errIsDir)
+	}
+	var data []byte
+	var entries []*info
+	if fi.dir {
+		entries, err = v.list(ctx, key)
+	} else {
+		_, data, err = v.read(ctx, key)
+	}
+	if err != nil {
+		return warn(err)
+	}
+
+	if cmd.times {
+		fmt.Fprintf(w, "T%d 0 %d 0\n", fi.mtime.Unix(), fi.mtime.Unix())
+		if err := scpAck(r); err != nil {
+			return err
+		}
+	}
+	if !fi.dir {
+		fmt.Fprintf(w, "C%04o %d %s\n", v.mode(fi)&0o777, len(data), name)
+		if err := scpAck(r); err != nil {
+			return err
+		}
+		w.Write(data)
+		w.Write([]byte{0})
+		return scpAck(r)
+	}
+
+	fmt.Fprintf(w, "D%04o 0 %s\n", v.mode(fi)&0o777, name)
+	if err := scpAck(r); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := v.scpSend(ctx, r, w, cmd, v.child(key, e.name), e.name, failed); err != nil {
+			return err
+		}
+	}
+	fmt.Fprint(w, "E\n")
+	return scpAck(r)
+}
@@ -0,0 +1,303 @@
+// Package sftp serves the files of the store over SSH, as the SFTP
+// subsystem (version 3) and to scp, so tools and scripts built on SSH
+// upload and download PeerVault files.
+//
+// Users sign in with one of their public keys and are chrooted to their
+// namespace, by default the folder named after their tenant: it is "/" to
+// them and no path leads out of it. Files they upload carry their tenant
+// in the "tenant" metadata entry. An upload is spooled to a temporary file
+// while the client writes it and stored when the client closes it; an
+// upload the client never closes is dropped. A download reads the file
+// from the store once per open. Ownership, permission bits and times are
+// not stored, and symbolic links are not supported.
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"golang.org/x/crypto/ssh"
+)
+
+// handshakeTimeout bounds the SSH handshake and authentication
+const handshakeTimeout = 30 * time.Second
+
+// User is who may sign in
+type User struct {
+	Name string
+	// Tenant owns the files the user uploads
+	Tenant string
+	// Namespace is the key prefix the user is chrooted to; empty is the
+	// folder named after the tenant, "/" is every key
+	Namespace string
+	// ReadOnly rejects every change
+	ReadOnly bool
+	// AuthorizedKeys are the public keys the user signs in with
+	AuthorizedKeys []ssh.PublicKey
+}
+
+// Config holds the SFTP server configuration
+type Config struct {
+	// HostKey is the key the server proves its identity with
+	HostKey ssh.Signer
+	Users   []User
+	// MaxFileSize is the largest file in bytes users may upload
+	MaxFileSize int64
+	// SpoolDir holds uploads until they are stored; empty is the system's
+	// temporary directory
+	SpoolDir string
+}
+
+// DefaultConfig returns the default SFTP server configuration, without a
+// host key or users
+func DefaultConfig() *Config {
+	return &Config{MaxFileSize: 1 << 30}
+}
+
+// Store holds the files served, see directory.Store
+type Store interface {
+	directory.Store
+	// Read returns the content of a file
+	Read(ctx context.Context, key string) ([]byte, error)
+	// Write stores the content of a file for tenant, replacing it
+	Write(ctx context.Context, key string, content io.Reader, tenant string) error
+}
+
+// Server answers SSH clients
+type Server struct {
+	store  Store
+	tree   *directory.Tree
+	config *Config
+	logger *slog.Logger
+	ssh    *ssh.ServerConfig
+	users  map[string]*User
+}
+
+// NewServer creates an SFTP server for the users of config
+func NewServer(store Store, config *Config, logger *slog.Logger) (*Server, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if config.HostKey == nil {
+		return nil, errors.New("sftp: a host key is required")
+	}
+	s := &Server{
+		store:  store,
+		tree:   directory.New(store),
+		config: config,
+		logger: logger,
+		users:  make(map[string]*User, len(config.Users)),
+	}
+	for _, u := range config.Users {
+		switch {
+		case u.Name == "":
+			return nil, errors.New("sftp: every user needs a name")
+		case s.users[u.Name] != nil:
+			return nil, fmt.Errorf("sftp: user %s is listed twice", u.Name)
+		case u.Tenant == "":
+			return nil, fmt.Errorf("sftp: user %s needs a tenant", u.Name)
+		case len(u.AuthorizedKeys) == 0:
+			return nil, fmt.Errorf("sftp: user %s has no authorized keys", u.Name)
+		}
+		if u.Namespace == "" {
+			u.Namespace = u.Tenant
+		}
+		namespace, err := directory.CleanDir(u.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("sftp: user %s: %w", u.Name, err)
+		}
+		u.Namespace = namespace
+		s.users[u.Name] = &u
+	}
+
+	s.ssh = &ssh.ServerConfig{
+		PublicKeyCallback: s.authenticate,
+		ServerVersion:     "SSH-2.0-PeerVault",
+	}
+	s.ssh.AddHostKey(config.HostKey)
+	return s, nil
+}
+
+// authenticate accepts the authorized keys of users
+func (s *Server) authenticate(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if u := s.users[meta.User()]; u != nil {
+		for _, authorized := range u.AuthorizedKeys {
+			if bytes.Equal(authorized.Marshal(), key.Marshal()) {
+				return &ssh.Permissions{}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("sftp: key %s is not authorized for %s", ssh.FingerprintSHA256(key), meta.User())
+}
+
+// LoadHostKey reads the host key stored at path, a PEM encoded private
+// key as written by ssh-keygen, creating and storing an Ed25519 key when
+// the file does not exist
+func LoadHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse host key %s: %w", path, err)
+		}
+		return signer, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read host key: %w", err)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate host key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode host key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create host key directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write host key: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, fmt.Errorf("failed to write host key: %w", err)
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+// Serve answers the clients accepting on listener until ctx is done
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	// Connections are closed when Serve returns, and waited for
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+	for {
+		nc, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(connCtx, nc)
+		}()
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, nc net.Conn) {
+	stop := context.AfterFunc(ctx, func() { nc.Close() })
+	defer stop()
+	defer nc.Close()
+
+	nc.SetDeadline(time.Now().Add(handshakeTimeout))
+	sc, channels, requests, err := ssh.NewServerConn(nc, s.ssh)
+	if err != nil {
+		s.logger.Debug("SSH handshake failed", "error", err, "remote", nc.RemoteAddr())
+		return
+	}
+	nc.SetDeadline(time.Time{})
+	defer sc.Close()
+	go ssh.DiscardRequests(requests)
+
+	u := s.users[sc.User()]
+	s.logger.Debug("SFTP user signed in", "user", u.Name, "remote", nc.RemoteAddr())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for nch := range channels {
+		if nch.ChannelType() != "session" {
+			nch.Reject(ssh.UnknownChannelType, "only sessions are served")
+			continue
+		}
+		ch, requests, err := nch.Accept()
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveSession(ctx, u, ch, requests)
+		}()
+	}
+}
+
+// serveSession runs the SFTP subsystem or scp in a session. Shells and
+// other commands are refused.
+func (s *Server) serveSession(ctx context.Context, u *User, ch ssh.Channel, requests <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range requests {
+		var run func() error
+		switch req.Type {
+		case "subsystem":
+			var payload struct{ Name string }
+			if ssh.Unmarshal(req.Payload, &payload) == nil && payload.Name == "sftp" {
+				run = func() error { return s.serveSFTP(ctx, u, ch) }
+			}
+		case "exec":
+			var payload struct{ Command string }
+			if ssh.Unmarshal(req.Payload, &payload) != nil {
+				break
+			}
+			cmd, err := parseSCP(payload.Command)
+			if err != nil {
+				req.Reply(true, nil)
+				fmt.Fprintf(ch.Stderr(), "%v\r\n", err)
+				exit(ch, 1)
+				return
+			}
+			run = func() error { return s.serveSCP(ctx, u, ch, cmd) }
+		case "shell":
+			req.Reply(true, nil)
+			fmt.Fprintf(ch.Stderr(), "PeerVault serves SFTP and scp only\r\n")
+			exit(ch, 1)
+			return
+		}
+		if run == nil {
+			req.Reply(false, nil)
+			continue
+		}
+
+		req.Reply(true, nil)
+		go ssh.DiscardRequests(requests)
+		status := 0
+		if err := run(); err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				s.logger.Debug("SFTP session failed", "user", u.Name, "error", err)
+			}
+			status = 1
+		}
+		exit(ch, status)
+		return
+	}
+}
+
+// exit reports the exit status of a session to the client
+func exit(ch ssh.Channel, status int) {
+	ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+	ch.CloseWrite()
+}
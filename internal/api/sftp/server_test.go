@@ -0,0 +1,549 @@
+package sftp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// memStore is a Store keeping content in memory
+type memStore struct {
+	mu      sync.Mutex
+	files   map[string][]byte
+	tenants map[string]string
+	mtime   map[string]time.Time
+}
+
+func newMemStore(files map[string]string) *memStore {
+	s := &memStore{files: make(map[string][]byte), tenants: make(map[string]string), mtime: make(map[string]time.Time)}
+	for k, v := range files {
+		s.files[k] = []byte(v)
+		s.mtime[k] = time.Now()
+	}
+	return s
+}
+
+func (s *memStore) List(ctx context.Context, prefix string) ([]directory.Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var objects []directory.Object
+	for k, data := range s.files {
+		if strings.HasPrefix(k, prefix) {
+			objects = append(objects, directory.Object{Key: k, Size: int64(len(data)), ModTime: s.mtime[k]})
+		}
+	}
+	return objects, nil
+}
+
+func (s *memStore) Copy(ctx context.Context, from, to string, overwrite bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[from]
+	if !ok {
+		return directory.ErrNotFound
+	}
+	if _, ok := s.files[to]; ok && !overwrite {
+		return directory.ErrExists
+	}
+	s.files[to], s.mtime[to] = data, time.Now()
+	return nil
+}
+
+func (s *memStore) Rename(ctx context.Context, from, to string, overwrite bool) error {
+	if err := s.Copy(ctx, from, to, overwrite); err != nil {
+		return err
+	}
+	return s.Delete(ctx, from)
+}
+
+func (s *memStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[key]; !ok {
+		return directory.ErrNotFound
+	}
+	delete(s.files, key)
+	return nil
+}
+
+func (s *memStore) Mkdir(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[key]; ok {
+		return directory.ErrExists
+	}
+	s.files[key], s.mtime[key] = nil, time.Now()
+	return nil
+}
+
+func (s *memStore) Read(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[key]
+	if !ok {
+		return nil, directory.ErrNotFound
+	}
+	return data, nil
+}
+
+func (s *memStore) Write(ctx context.Context, key string, content io.Reader, tenant string) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[key], s.tenants[key], s.mtime[key] = data, tenant, time.Now()
+	return nil
+}
+
+func (s *memStore) get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[key]
+	return string(data), ok
+}
+
+func newSigner(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	return signer
+}
+
+// startServer serves store to users and returns a dial function for them
+func startServer(t *testing.T, store Store, users ...User) func(name string, key ssh.Signer) (*ssh.Client, error) {
+	t.Helper()
+	config := DefaultConfig()
+	config.HostKey = newSigner(t)
+	config.Users = users
+	config.MaxFileSize = 1 << 20
+	config.SpoolDir = t.TempDir()
+	server, err := NewServer(store, config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.Serve(ctx, listener)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return func(name string, key ssh.Signer) (*ssh.Client, error) {
+		return ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+			User:            name,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(key)},
+			HostKeyCallback: ssh.FixedHostKey(config.HostKey.PublicKey()),
+			Timeout:         5 * time.Second,
+		})
+	}
+}
+
+// client speaks SFTP the way clients do
+type client struct {
+	t  *testing.T
+	w  io.Writer
+	r  *bufio.Reader
+	id uint32
+}
+
+func newClient(t *testing.T, conn *ssh.Client) *client {
+	t.Helper()
+	session, err := conn.NewSession()
+	require.NoError(t, err)
+	t.Cleanup(func() { session.Close() })
+	w, err := session.StdinPipe()
+	require.NoError(t, err)
+	r, err := session.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, session.RequestSubsystem("sftp"))
+
+	c := &client{t: t, w: w, r: bufio.NewReader(r)}
+	d := c.send(fxpInit, func(e *encoder) { e.uint32(sftpVersion) }, false)
+	require.Equal(t, byte(fxpVersion), d.byte())
+	require.Equal(t, uint32(sftpVersion), d.uint32())
+	return c
+}
+
+// send sends a packet and returns the reply, its type and, when withID is
+// set, its id already read
+func (c *client) send(typ byte, fields func(e *encoder), withID bool) *decoder {
+	c.t.Helper()
+	e := &encoder{}
+	e.byte(typ)
+	if withID {
+		c.id++
+		e.uint32(c.id)
+	}
+	fields(e)
+	_, err := c.w.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(e.buf))), e.buf...))
+	require.NoError(c.t, err)
+
+	var header [4]byte
+	_, err = io.ReadFull(c.r, header[:])
+	require.NoError(c.t, err)
+	packet := make([]byte, binary.BigEndian.Uint32(header[:]))
+	_, err = io.ReadFull(c.r, packet)
+	require.NoError(c.t, err)
+	return &decoder{buf: packet}
+}
+
+// call sends a request and returns the type of the reply and its fields
+func (c *client) call(typ byte, fields func(e *encoder)) (byte, *decoder) {
+	c.t.Helper()
+	d := c.send(typ, fields, true)
+	reply := d.byte()
+	require.Equal(c.t, c.id, d.uint32())
+	return reply, d
+}
+
+// status calls and expects a STATUS reply, returning its code
+func (c *client) status(typ byte, fields func(e *encoder)) uint32 {
+	c.t.Helper()
+	reply, d := c.call(typ, fields)
+	require.Equal(c.t, byte(fxpStatus), reply)
+	return d.uint32()
+}
+
+func (c *client) open(p string, flags uint32) (string, uint32) {
+	c.t.Helper()
+	reply, d := c.call(fxpOpen, func(e *encoder) {
+		e.string(p)
+		e.uint32(flags)
+		e.uint32(0)
+	})
+	if reply == fxpStatus {
+		return "", d.uint32()
+	}
+	require.Equal(c.t, byte(fxpHandle), reply)
+	return d.string(), fxOK
+}
+
+func (c *client) put(p, content string) uint32 {
+	c.t.Helper()
+	handle, code := c.open(p, fxfWrite|fxfCreat|fxfTrunc)
+	if code != fxOK {
+		return code
+	}
+	for offset := 0; offset < len(content); offset += 4 {
+		end := min(offset+4, len(content))
+		require.Equal(c.t, uint32(fxOK), c.status(fxpWrite, func(e *encoder) {
+			e.string(handle)
+			e.uint64(uint64(offset))
+			e.string(content[offset:end])
+		}))
+	}
+	return c.status(fxpClose, func(e *encoder) { e.string(handle) })
+}
+
+func (c *client) get(p string) (string, uint32) {
+	c.t.Helper()
+	handle, code := c.open(p, fxfRead)
+	if code != fxOK {
+		return "", code
+	}
+	var content bytes.Buffer
+	for {
+		reply, d := c.call(fxpRead, func(e *encoder) {
+			e.string(handle)
+			e.uint64(uint64(content.Len()))
+			e.uint32(3)
+		})
+		if reply == fxpStatus {
+			require.Equal(c.t, uint32(fxEOF), d.uint32())
+			break
+		}
+		require.Equal(c.t, byte(fxpData), reply)
+		content.WriteString(d.string())
+	}
+	require.Equal(c.t, uint32(fxOK), c.status(fxpClose, func(e *encoder) { e.string(handle) }))
+	return content.String(), fxOK
+}
+
+func (c *client) readdir(p string) []string {
+	c.t.Helper()
+	reply, d := c.call(fxpOpendir, func(e *encoder) { e.string(p) })
+	require.Equal(c.t, byte(fxpHandle), reply)
+	handle := d.string()
+	var names []string
+	for {
+		reply, d := c.call(fxpReaddir, func(e *encoder) { e.string(handle) })
+		if reply == fxpStatus {
+			require.Equal(c.t, uint32(fxEOF), d.uint32())
+			return names
+		}
+		for range d.uint32() {
+			name, long := d.string(), d.string()
+			d.attrs()
+			assert.Contains(c.t, long, name)
+			names = append(names, name)
+		}
+	}
+}
+
+func (c *client) realpath(p string) string {
+	c.t.Helper()
+	reply, d := c.call(fxpRealpath, func(e *encoder) { e.string(p) })
+	require.Equal(c.t, byte(fxpName), reply)
+	require.Equal(c.t, uint32(1), d.uint32())
+	return d.string()
+}
+
+func pathFields(paths ...string) func(e *encoder) {
+	return func(e *encoder) {
+		for _, p := range paths {
+			e.string(p)
+		}
+	}
+}
+
+func TestSFTPChrootedToTenant(t *testing.T) {
+	store := newMemStore(map[string]string{
+		"acme/reports/q1.txt": "first quarter",
+		"other/secret.txt":    "not for acme",
+	})
+	key := newSigner(t)
+	dial := startServer(t, store, User{Name: "alice", Tenant: "acme", AuthorizedKeys: []ssh.PublicKey{key.PublicKey()}})
+	conn, err := dial("alice", key)
+	require.NoError(t, err)
+	defer conn.Close()
+	c := newClient(t, conn)
+
+	assert.Equal(t, "/", c.realpath("."))
+	assert.Equal(t, "/", c.realpath("/../.."))
+	assert.Equal(t, []string{"reports"}, c.readdir("/"))
+	assert.Equal(t, []string{"q1.txt"}, c.readdir("../reports"))
+
+	content, code := c.get("/reports/q1.txt")
+	require.Equal(t, uint32(fxOK), code)
+	assert.Equal(t, "first quarter", content)
+	_, code = c.get("/../other/secret.txt")
+	assert.Equal(t, uint32(fxNoSuchFile), code)
+
+	require.Equal(t, uint32(fxOK), c.put("/../../uploads/report.csv", "a,b\n1,2\n"))
+	got, ok := store.get("acme/uploads/report.csv")
+	require.True(t, ok)
+	assert.Equal(t, "a,b\n1,2\n", got)
+	assert.Equal(t, "acme", store.tenants["acme/uploads/report.csv"])
+	_, ok = store.get("uploads/report.csv")
+	assert.False(t, ok)
+
+	reply, d := c.call(fxpStat, pathFields("/uploads/report.csv"))
+	require.Equal(t, byte(fxpAttrs), reply)
+	size, _ := d.attrs()
+	assert.Equal(t, int64(8), size)
+}
+
+func TestSFTPChanges(t *testing.T) {
+	store := newMemStore(map[string]string{"acme/a.txt": "hello world"})
+	key := newSigner(t)
+	dial := startServer(t, store, User{Name: "alice", Tenant: "acme", AuthorizedKeys: []ssh.PublicKey{key.PublicKey()}})
+	conn, err := dial("alice", key)
+	require.NoError(t, err)
+	defer conn.Close()
+	c := newClient(t, conn)
+
+	// Writes without truncating start from the stored content
+	handle, code := c.open("/a.txt", fxfWrite)
+	require.Equal(t, uint32(fxOK), code)
+	require.Equal(t, uint32(fxOK), c.status(fxpWrite, func(e *encoder) {
+		e.string(handle)
+		e.uint64(6)
+		e.string("there")
+	}))
+	got, _ := store.get("acme/a.txt")
+	assert.Equal(t, "hello world", got, "stored once closed")
+	require.Equal(t, uint32(fxOK), c.status(fxpClose, pathFields(handle)))
+	got, _ = store.get("acme/a.txt")
+	assert.Equal(t, "hello there", got)
+
+	_, code = c.open("/a.txt", fxfWrite|fxfCreat|fxfExcl)
+	assert.Equal(t, uint32(fxFailure), code)
+	_, code = c.open("/missing.txt", fxfWrite)
+	assert.Equal(t, uint32(fxNoSuchFile), code)
+
+	require.Equal(t, uint32(fxOK), c.status(fxpMkdir, func(e *encoder) {
+		e.string("/docs")
+		e.uint32(0)
+	}))
+	assert.Equal(t, uint32(fxOK), c.status(fxpRename, pathFields("/a.txt", "/docs/a.txt")))
+	assert.Equal(t, uint32(fxFailure), c.status(fxpRmdir, pathFields("/docs")), "not empty")
+	require.Equal(t, uint32(fxOK), c.put("/b.txt", "b"))
+	assert.Equal(t, uint32(fxFailure), c.status(fxpRename, pathFields("/b.txt", "/docs/a.txt")))
+	assert.Equal(t, uint32(fxOK), c.status(fxpExtended, pathFields(posixRename, "/b.txt", "/docs/a.txt")))
+	got, _ = store.get("acme/docs/a.txt")
+	assert.Equal(t, "b", got)
+
+	assert.Equal(t, uint32(fxOK), c.status(fxpRemove, pathFields("/docs/a.txt")))
+	assert.Equal(t, uint32(fxOK), c.status(fxpRmdir, pathFields("/docs")))
+	assert.Empty(t, c.readdir("/"))
+	assert.Equal(t, uint32(fxOpUnsupported), c.status(fxpSymlink, pathFields("/x", "/y")))
+}
+
+func TestSFTPUploadDroppedUnlessClosed(t *testing.T) {
+	store := newMemStore(nil)
+	key := newSigner(t)
+	dial := startServer(t, store, User{Name: "alice", Tenant: "acme", AuthorizedKeys: []ssh.PublicKey{key.PublicKey()}})
+	conn, err := dial("alice", key)
+	require.NoError(t, err)
+	c := newClient(t, conn)
+
+	handle, code := c.open("/partial.bin", fxfWrite|fxfCreat)
+	require.Equal(t, uint32(fxOK), code)
+	require.Equal(t, uint32(fxOK), c.status(fxpWrite, func(e *encoder) {
+		e.string(handle)
+		e.uint64(0)
+		e.string("half")
+	}))
+	conn.Close()
+
+	time.Sleep(100 * time.Millisecond)
+	_, ok := store.get("acme/partial.bin")
+	assert.False(t, ok)
+}
+
+func TestSFTPAuthentication(t *testing.T) {
+	store := newMemStore(map[string]string{"acme/a.txt": "a"})
+	key, other := newSigner(t), newSigner(t)
+	dial := startServer(t, store,
+		User{Name: "alice", Tenant: "acme", AuthorizedKeys: []ssh.PublicKey{key.PublicKey()}},
+		User{Name: "auditor", Tenant: "acme", ReadOnly: true, AuthorizedKeys: []ssh.PublicKey{other.PublicKey()}},
+	)
+
+	_, err := dial("alice", other)
+	assert.Error(t, err)
+	_, err = dial("mallory", key)
+	assert.Error(t, err)
+
+	conn, err := dial("auditor", other)
+	require.NoError(t, err)
+	defer conn.Close()
+	c := newClient(t, conn)
+	content, code := c.get("/a.txt")
+	require.Equal(t, uint32(fxOK), code)
+	assert.Equal(t, "a", content)
+	assert.Equal(t, uint32(fxPermissionDenied), c.put("/b.txt", "b"))
+	assert.Equal(t, uint32(fxPermissionDenied), c.status(fxpRemove, pathFields("/a.txt")))
+
+	// Only SFTP and scp are served
+	session, err := conn.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	err = session.Run("cat /etc/passwd")
+	var exit *ssh.ExitError
+	require.ErrorAs(t, err, &exit)
+	assert.Equal(t, 1, exit.ExitStatus())
+}
+
+func TestSCP(t *testing.T) {
+	store := newMemStore(map[string]string{"acme/docs/readme.txt": "read me"})
+	key := newSigner(t)
+	dial := startServer(t, store, User{Name: "alice", Tenant: "acme", AuthorizedKeys: []ssh.PublicKey{key.PublicKey()}})
+	conn, err := dial("alice", key)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	run := func(command, input string) (string, error) {
+		session, err := conn.NewSession()
+		require.NoError(t, err)
+		defer session.Close()
+		session.Stdin = strings.NewReader(input)
+		var out bytes.Buffer
+		session.Stdout = &out
+		err = session.Run(command)
+		return out.String(), err
+	}
+
+	// Upload a file and a directory into /docs
+	out, err := run("scp -r -t -- '/docs'", "C0644 5 new.txt\nhello\x00D0755 0 sub\nC0644 3 x.txt\nxyz\x00E\n")
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("\x00", 7), out)
+	got, _ := store.get("acme/docs/new.txt")
+	assert.Equal(t, "hello", got)
+	got, _ = store.get("acme/docs/sub/x.txt")
+	assert.Equal(t, "xyz", got)
+	assert.Equal(t, "acme", store.tenants["acme/docs/sub/x.txt"])
+
+	// Download a file
+	out, err = run("scp -f /docs/readme.txt", "\x00\x00\x00")
+	require.NoError(t, err)
+	assert.Equal(t, "C0644 7 readme.txt\nread me\x00", out)
+
+	// Download a directory
+	out, err = run("scp -r -f /docs/sub", strings.Repeat("\x00", 5))
+	require.NoError(t, err)
+	assert.Equal(t, "D0755 0 sub\nC0644 3 x.txt\nxyz\x00E\n", out)
+
+	// Missing files are reported
+	out, err = run("scp -f /missing.txt", "\x00")
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(out, "\x01scp: missing.txt: "), out)
+}
+
+func TestParseSCP(t *testing.T) {
+	cmd, err := parseSCP(`scp -v -r -t -- '/my files/it'"'"'s here'`)
+	require.NoError(t, err)
+	assert.True(t, cmd.sink)
+	assert.True(t, cmd.recursive)
+	assert.Equal(t, []string{"/my files/it's here"}, cmd.paths)
+
+	cmd, err = parseSCP(`scp -pf a\ b c`)
+	require.NoError(t, err)
+	assert.False(t, cmd.sink)
+	assert.True(t, cmd.times)
+	assert.Equal(t, []string{"a b", "c"}, cmd.paths)
+
+	for _, command := range []string{"ls", "scp /a", "scp -t -f /a", "scp -t a b", "scp -x -t a"} {
+		_, err := parseSCP(command)
+		assert.Error(t, err, command)
+	}
+}
+
+func TestNewServerValidatesUsers(t *testing.T) {
+	key := newSigner(t).PublicKey()
+	for i, users := range [][]User{
+		{{Tenant: "acme", AuthorizedKeys: []ssh.PublicKey{key}}},
+		{{Name: "alice", AuthorizedKeys: []ssh.PublicKey{key}}},
+		{{Name: "alice", Tenant: "acme"}},
+		{{Name: "alice", Tenant: "acme", AuthorizedKeys: []ssh.PublicKey{key}}, {Name: "alice", Tenant: "b", AuthorizedKeys: []ssh.PublicKey{key}}},
+		{{Name: "alice", Tenant: "acme", Namespace: "../x", AuthorizedKeys: []ssh.PublicKey{key}}},
+	} {
+		_, err := NewServer(newMemStore(nil), &Config{HostKey: newSigner(t), Users: users, MaxFileSize: 1}, nil)
+		assert.Error(t, err, fmt.Sprint(i))
+	}
+	_, err := NewServer(newMemStore(nil), &Config{Users: nil}, nil)
+	assert.Error(t, err, "a host key is required")
+}
+
+func TestLoadHostKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "host_key")
+	created, err := LoadHostKey(path)
+	require.NoError(t, err)
+	loaded, err := LoadHostKey(path)
+	require.NoError(t, err)
+	assert.Equal(t, created.PublicKey().Marshal(), loaded.PublicKey().Marshal())
+}
@@ -0,0 +1,602 @@
+package sftp
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"github.com/Skpow1234/Peervault/internal/retention"
+)
+
+// The SFTP protocol, version 3 (draft-ietf-secsh-filexfer-02), as spoken
+// by OpenSSH and most clients
+
+const sftpVersion = 3
+
+// Packet types
+const (
+	fxpInit          = 1
+	fxpVersion       = 2
+	fxpOpen          = 3
+	fxpClose         = 4
+	fxpRead          = 5
+	fxpWrite         = 6
+	fxpLstat         = 7
+	fxpFstat         = 8
+	fxpSetstat       = 9
+	fxpFsetstat      = 10
+	fxpOpendir       = 11
+	fxpReaddir       = 12
+	fxpRemove        = 13
+	fxpMkdir         = 14
+	fxpRmdir         = 15
+	fxpRealpath      = 16
+	fxpStat          = 17
+	fxpRename        = 18
+	fxpReadlink      = 19
+	fxpSymlink       = 20
+	fxpStatus        = 101
+	fxpHandle        = 102
+	fxpData          = 103
+	fxpName          = 104
+	fxpAttrs         = 105
+	fxpExtended      = 200
+	fxpExtendedReply = 201
+)
+
+// Status codes
+const (
+	fxOK               = 0
+	fxEOF              = 1
+	fxNoSuchFile       = 2
+	fxPermissionDenied = 3
+	fxFailure          = 4
+	fxBadMessage       = 5
+	fxOpUnsupported    = 8
+)
+
+// Open flags
+const (
+	fxfRead   = 0x01
+	fxfWrite  = 0x02
+	fxfAppend = 0x04
+	fxfCreat  = 0x08
+	fxfTrunc  = 0x10
+	fxfExcl   = 0x20
+)
+
+// Attribute flags
+const (
+	attrSize        = 0x01
+	attrUIDGID      = 0x02
+	attrPermissions = 0x04
+	attrACModTime   = 0x08
+	attrExtended    = 0x80000000
+)
+
+const (
+	// maxPacket is the largest packet in bytes clients may send
+	maxPacket = 1 << 20
+	// maxRead is the most bytes a read returns
+	maxRead = 1 << 16
+	// namesPerReply is the most entries a READDIR reply holds
+	namesPerReply = 100
+	// posixRename is the OpenSSH extension renaming over existing files
+	posixRename = "posix-rename@openssh.com"
+)
+
+// errBadMessage is returned for packets that cannot be decoded
+var errBadMessage = errors.New("sftp: malformed packet")
+
+// decoder reads the fields of a packet; the first error sticks
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil || n < 0 || n > len(d.buf) {
+		d.err = errBadMessage
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.take(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) string() string {
+	return string(d.take(int(d.uint32())))
+}
+
+// attrs reads attributes, returning the size when they set one
+func (d *decoder) attrs() (size int64, hasSize bool) {
+	flags := d.uint32()
+	if flags&attrSize != 0 {
+		size, hasSize = int64(d.uint64()), true
+	}
+	if flags&attrUIDGID != 0 {
+		d.uint32()
+		d.uint32()
+	}
+	if flags&attrPermissions != 0 {
+		d.uint32()
+	}
+	if flags&attrACModTime != 0 {
+		d.uint32()
+		d.uint32()
+	}
+	if flags&attrExtended != 0 {
+		for range d.uint32() {
+			d.string()
+			d.string()
+		}
+	}
+	return size, hasSize
+}
+
+// encoder builds a packet
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) byte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, v)
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, v)
+}
+
+func (e *encoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// sftpSession is the SFTP subsystem of a session
+type sftpSession struct {
+	*view
+	w *bufio.Writer
+	// handles are the open files and directories by handle
+	handles map[string]any
+	next    uint64
+}
+
+// reading is a file opened for reading
+type reading struct {
+	info *info
+	data []byte
+}
+
+// writing is a file opened for writing
+type writing struct {
+	*upload
+	append bool
+}
+
+// listing is an open directory
+type listing struct {
+	entries []*info
+}
+
+// serveSFTP runs the SFTP subsystem on rw until the client closes it
+func (s *Server) serveSFTP(ctx context.Context, u *User, rw io.ReadWriter) error {
+	sess := &sftpSession{
+		view:    &view{server: s, user: u},
+		w:       bufio.NewWriter(rw),
+		handles: make(map[string]any),
+	}
+	defer sess.closeAll()
+
+	r := bufio.NewReader(rw)
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+		n := binary.BigEndian.Uint32(header[:])
+		if n == 0 || n > maxPacket {
+			return fmt.Errorf("sftp: packet of %d bytes", n)
+		}
+		packet := make([]byte, n)
+		if _, err := io.ReadFull(r, packet); err != nil {
+			return err
+		}
+		reply := sess.handle(ctx, &decoder{buf: packet})
+		if reply == nil {
+			continue
+		}
+		sess.w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(reply))))
+		sess.w.Write(reply)
+		if err := sess.w.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// closeAll drops the uploads the client did not close
+func (s *sftpSession) closeAll() {
+	for _, h := range s.handles {
+		if w, ok := h.(*writing); ok {
+			w.discard()
+		}
+	}
+}
+
+// handle answers a packet
+func (s *sftpSession) handle(ctx context.Context, d *decoder) []byte {
+	typ := d.byte()
+	if typ == fxpInit {
+		e := &encoder{}
+		e.byte(fxpVersion)
+		e.uint32(sftpVersion)
+		e.string(posixRename)
+		e.string("1")
+		return e.buf
+	}
+
+	id := d.uint32()
+	if d.err != nil {
+		return nil
+	}
+	e := &encoder{}
+	var err error
+	switch typ {
+	case fxpOpen:
+		err = s.open(ctx, d, e, id)
+	case fxpClose:
+		err = s.close(ctx, d)
+	case fxpRead:
+		err = s.read(d, e, id)
+	case fxpWrite:
+		err = s.write(d)
+	case fxpStat, fxpLstat:
+		err = s.statPath(ctx, d, e, id)
+	case fxpFstat:
+		err = s.statHandle(d, e, id)
+	case fxpSetstat:
+		err = s.setstat(ctx, d)
+	case fxpFsetstat:
+		err = s.fsetstat(d)
+	case fxpOpendir:
+		err = s.opendir(ctx, d, e, id)
+	case fxpReaddir:
+		err = s.readdir(d, e, id)
+	case fxpRemove:
+		err = s.remove(ctx, s.key(d.string()), false)
+	case fxpRmdir:
+		err = s.remove(ctx, s.key(d.string()), true)
+	case fxpMkdir:
+		p := d.string()
+		d.attrs()
+		if d.err == nil {
+			err = s.mkdir(ctx, s.key(p))
+		}
+	case fxpRealpath:
+		p := clean(d.string())
+		if d.err == nil {
+			e.byte(fxpName)
+			e.uint32(id)
+			e.uint32(1)
+			e.string(p)
+			e.string(p)
+			e.uint32(0)
+		}
+	case fxpRename:
+		from, to := d.string(), d.string()
+		if d.err == nil {
+			err = s.rename(ctx, s.key(from), s.key(to), false)
+		}
+	case fxpExtended:
+		if d.string() != posixRename {
+			return status(id, fxOpUnsupported, "unsupported extension")
+		}
+		from, to := d.string(), d.string()
+		if d.err == nil {
+			err = s.rename(ctx, s.key(from), s.key(to), true)
+		}
+	case fxpReadlink, fxpSymlink:
+		return status(id, fxOpUnsupported, "symbolic links are not supported")
+	default:
+		return status(id, fxOpUnsupported, "unsupported operation")
+	}
+
+	switch {
+	case d.err != nil:
+		return status(id, fxBadMessage, d.err.Error())
+	case err != nil:
+		return status(id, statusOf(err), err.Error())
+	case len(e.buf) == 0:
+		return status(id, fxOK, "")
+	}
+	return e.buf
+}
+
+// status returns a STATUS reply
+func status(id, code uint32, message string) []byte {
+	e := &encoder{}
+	e.byte(fxpStatus)
+	e.uint32(id)
+	e.uint32(code)
+	e.string(message)
+	e.string("en")
+	return e.buf
+}
+
+// statusOf maps an error to a status code
+func statusOf(err error) uint32 {
+	switch {
+	case errors.Is(err, io.EOF):
+		return fxEOF
+	case errors.Is(err, directory.ErrNotFound):
+		return fxNoSuchFile
+	case errors.Is(err, errReadOnly), errors.Is(err, retention.ErrLocked):
+		return fxPermissionDenied
+	default:
+		return fxFailure
+	}
+}
+
+// errBadHandle is returned for handles that are not open
+var errBadHandle = errors.New("sftp: invalid handle")
+
+func (s *sftpSession) add(h any) string {
+	s.next++
+	handle := strconv.FormatUint(s.next, 10)
+	s.handles[handle] = h
+	return handle
+}
+
+func (s *sftpSession) replyHandle(e *encoder, id uint32, handle string) {
+	e.byte(fxpHandle)
+	e.uint32(id)
+	e.string(handle)
+}
+
+func (s *sftpSession) open(ctx context.Context, d *decoder, e *encoder, id uint32) error {
+	p, flags := d.string(), d.uint32()
+	d.attrs()
+	if d.err != nil {
+		return nil
+	}
+	key := s.key(p)
+	if flags&(fxfWrite|fxfAppend) == 0 {
+		fi, data, err := s.view.read(ctx, key)
+		if err != nil {
+			return err
+		}
+		s.replyHandle(e, id, s.add(&reading{info: fi, data: data}))
+		return nil
+	}
+
+	if flags&fxfCreat == 0 {
+		if _, err := s.stat(ctx, key); err != nil {
+			return err
+		}
+	}
+	u, err := s.create(ctx, key, flags&fxfTrunc != 0, flags&fxfExcl != 0)
+	if err != nil {
+		return err
+	}
+	s.replyHandle(e, id, s.add(&writing{upload: u, append: flags&fxfAppend != 0}))
+	return nil
+}
+
+func (s *sftpSession) close(ctx context.Context, d *decoder) error {
+	handle := d.string()
+	h, ok := s.handles[handle]
+	if !ok {
+		return errBadHandle
+	}
+	delete(s.handles, handle)
+	if w, ok := h.(*writing); ok {
+		return w.store(ctx)
+	}
+	return nil
+}
+
+func (s *sftpSession) read(d *decoder, e *encoder, id uint32) error {
+	h, offset, n := s.handles[d.string()], d.uint64(), min(d.uint32(), maxRead)
+	if d.err != nil {
+		return nil
+	}
+	var data []byte
+	switch h := h.(type) {
+	case *reading:
+		if offset >= uint64(len(h.data)) {
+			return io.EOF
+		}
+		data = h.data[offset:min(offset+uint64(n), uint64(len(h.data)))]
+	case *writing:
+		data = make([]byte, n)
+		read, err := h.ReadAt(data, int64(offset))
+		if read == 0 {
+			return err
+		}
+		data = data[:read]
+	default:
+		return errBadHandle
+	}
+	e.byte(fxpData)
+	e.uint32(id)
+	e.string(string(data))
+	return nil
+}
+
+func (s *sftpSession) write(d *decoder) error {
+	h, offset, data := s.handles[d.string()], d.uint64(), d.take(int(d.uint32()))
+	if d.err != nil {
+		return nil
+	}
+	w, ok := h.(*writing)
+	if !ok {
+		if _, ok := h.(*reading); ok {
+			return errors.New("sftp: file not open for writing")
+		}
+		return errBadHandle
+	}
+	if w.append {
+		offset = uint64(w.size)
+	}
+	_, err := w.WriteAt(data, int64(offset))
+	return err
+}
+
+// attrs writes the attributes of fi
+func (s *sftpSession) attrs(e *encoder, fi *info) {
+	e.uint32(attrSize | attrPermissions | attrACModTime)
+	e.uint64(uint64(fi.size))
+	e.uint32(s.mode(fi))
+	e.uint32(uint32(fi.mtime.Unix()))
+	e.uint32(uint32(fi.mtime.Unix()))
+}
+
+// longname formats fi the way ls -l does
+func (s *sftpSession) longname(fi *info) string {
+	perm := []byte("-rwxrwxrwx")
+	mode := s.mode(fi)
+	if fi.dir {
+		perm[0] = 'd'
+	}
+	for i := range 9 {
+		if mode&(1<<(8-i)) == 0 {
+			perm[i+1] = '-'
+		}
+	}
+	layout := "Jan _2 15:04"
+	if time.Since(fi.mtime) > 180*24*time.Hour {
+		layout = "Jan _2  2006"
+	}
+	return fmt.Sprintf("%s 1 %-8s %-8s %8d %s %s", perm, s.user.Name, s.user.Tenant, fi.size, fi.mtime.Format(layout), fi.name)
+}
+
+func (s *sftpSession) replyAttrs(e *encoder, id uint32, fi *info) {
+	e.byte(fxpAttrs)
+	e.uint32(id)
+	s.attrs(e, fi)
+}
+
+func (s *sftpSession) statPath(ctx context.Context, d *decoder, e *encoder, id uint32) error {
+	p := d.string()
+	if d.err != nil {
+		return nil
+	}
+	fi, err := s.stat(ctx, s.key(p))
+	if err != nil {
+		return err
+	}
+	s.replyAttrs(e, id, fi)
+	return nil
+}
+
+func (s *sftpSession) statHandle(d *decoder, e *encoder, id uint32) error {
+	switch h := s.handles[d.string()].(type) {
+	case *reading:
+		s.replyAttrs(e, id, h.info)
+	case *writing:
+		s.replyAttrs(e, id, &info{name: directory.Base(h.key), size: h.size, mtime: time.Now()})
+	default:
+		if d.err == nil {
+			return errBadHandle
+		}
+	}
+	return nil
+}
+
+// setstat changes the size of a file; other attributes are not stored and
+// are ignored
+func (s *sftpSession) setstat(ctx context.Context, d *decoder) error {
+	p := d.string()
+	size, hasSize := d.attrs()
+	if d.err != nil || !hasSize {
+		return nil
+	}
+	return s.truncate(ctx, s.key(p), size)
+}
+
+func (s *sftpSession) fsetstat(d *decoder) error {
+	h := s.handles[d.string()]
+	size, hasSize := d.attrs()
+	if d.err != nil {
+		return nil
+	}
+	switch h := h.(type) {
+	case *writing:
+		if hasSize {
+			return h.Truncate(size)
+		}
+	case *reading:
+		if hasSize {
+			return errors.New("sftp: file not open for writing")
+		}
+	default:
+		return errBadHandle
+	}
+	return nil
+}
+
+func (s *sftpSession) opendir(ctx context.Context, d *decoder, e *encoder, id uint32) error {
+	p := d.string()
+	if d.err != nil {
+		return nil
+	}
+	entries, err := s.list(ctx, s.key(p))
+	if err != nil {
+		return err
+	}
+	s.replyHandle(e, id, s.add(&listing{entries: entries}))
+	return nil
+}
+
+func (s *sftpSession) readdir(d *decoder, e *encoder, id uint32) error {
+	h, ok := s.handles[d.string()].(*listing)
+	switch {
+	case d.err != nil:
+		return nil
+	case !ok:
+		return errBadHandle
+	case len(h.entries) == 0:
+		return io.EOF
+	}
+	n := min(len(h.entries), namesPerReply)
+	e.byte(fxpName)
+	e.uint32(id)
+	e.uint32(uint32(n))
+	for _, fi := range h.entries[:n] {
+		e.string(fi.name)
+		e.string(s.longname(fi))
+		s.attrs(e, fi)
+	}
+	h.entries = h.entries[n:]
+	return nil
+}
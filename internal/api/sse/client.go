@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 )
@@ -26,9 +27,12 @@ type Client struct {
 	// Client ID
 	ID string
 
-	// Subscribed topics
+	// Subscribed topics; empty receives every topic
 	subscriptions map[string]bool
 
+	// Event types the client receives; empty receives every type
+	eventTypes map[string]bool
+
 	// Logger
 	logger *slog.Logger
 
@@ -52,6 +56,9 @@ type Event struct {
 	Data      interface{} `json:"data"`
 	Timestamp time.Time   `json:"timestamp"`
 	ID        string      `json:"id,omitempty"`
+
+	// Number of a broadcast event, which is its ID
+	seq uint64
 }
 
 // NewClient creates a new SSE client
@@ -64,6 +71,7 @@ func NewClient(w http.ResponseWriter, r *http.Request, logger *slog.Logger) *Cli
 		send:          make(chan *Event, 256), // Buffer for 256 events
 		ID:            generateClientID(),
 		subscriptions: make(map[string]bool),
+		eventTypes:    make(map[string]bool),
 		logger:        logger,
 		ctx:           ctx,
 		cancel:        cancel,
@@ -82,13 +90,11 @@ func generateClientID() string {
 	return hex.EncodeToString(bytes)
 }
 
-// Handle handles the SSE client connection
+// Handle handles the SSE client connection. It sends events until the
+// connection closes; writing from the handler's goroutine keeps writes from
+// racing with the server finishing the response.
 func (c *Client) Handle() {
-	// Start goroutine to send events
-	go c.writePump()
-
-	// Wait for context cancellation or connection close
-	<-c.ctx.Done()
+	c.writePump()
 
 	c.logger.Info("SSE client connection closed",
 		"clientId", c.ID,
@@ -183,13 +189,13 @@ func splitLines(s string) []string {
 	return lines
 }
 
-// SendEvent sends an event to the client
+// SendEvent sends an event to the client. It has no ID, so it does not
+// change where the client resumes after reconnecting.
 func (c *Client) SendEvent(eventType string, data interface{}) {
 	event := &Event{
 		Type:      eventType,
 		Data:      data,
 		Timestamp: time.Now(),
-		ID:        generateEventID(),
 	}
 
 	select {
@@ -199,13 +205,6 @@ func (c *Client) SendEvent(eventType string, data interface{}) {
 	}
 }
 
-// generateEventID generates a unique event ID
-func generateEventID() string {
-	bytes := make([]byte, 8)
-	_, _ = rand.Read(bytes) // Ignore error for event ID generation
-	return hex.EncodeToString(bytes)
-}
-
 // SubscribeToTopic subscribes the client to a topic
 func (c *Client) SubscribeToTopic(topic string) {
 	c.mu.Lock()
@@ -224,17 +223,44 @@ func (c *Client) UnsubscribeFromTopic(topic string) {
 	c.logger.Info("SSE client unsubscribed from topic", "clientId", c.ID, "topic", topic)
 }
 
+// FilterEventTypes makes the client receive only events of the given types
+func (c *Client) FilterEventTypes(eventTypes ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, eventType := range eventTypes {
+		c.eventTypes[eventType] = true
+	}
+}
+
 // IsSubscribedToTopic checks if the client is subscribed to a topic
 func (c *Client) IsSubscribedToTopic(topic string) bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	// If no subscriptions, client receives all events
-	if len(c.subscriptions) == 0 {
+	return matches(c.subscriptions, topic)
+}
+
+// Accepts checks if the client's topics and event types let an event through
+func (c *Client) Accepts(event *Event) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return matches(c.subscriptions, event.Topic) && matches(c.eventTypes, event.Type)
+}
+
+// matches checks a value against patterns, which match exactly or, ending in
+// "*", by prefix. No patterns match everything.
+func matches(patterns map[string]bool, value string) bool {
+	if len(patterns) == 0 || patterns[value] {
 		return true
 	}
-
-	return c.subscriptions[topic]
+	for pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
 }
 
 // GetSubscriptions returns the client's subscriptions
@@ -250,10 +276,10 @@ func (c *Client) GetSubscriptions() []string {
 	return topics
 }
 
-// Close closes the client connection
+// Close closes the client connection. The send channel stays open, as the
+// hub may still hold the client while it broadcasts.
 func (c *Client) Close() {
 	c.cancel()
-	close(c.send)
 }
 
 // GetConnectionInfo returns connection information
@@ -2,12 +2,19 @@ package sse
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"strconv"
 	"sync"
 	"time"
 )
 
-// Hub manages SSE connections and event broadcasting
+// ErrTooManyConnections is returned when registering a client with a full hub
+var ErrTooManyConnections = errors.New("maximum SSE connections reached")
+
+// Hub manages SSE connections and event broadcasting. Broadcast events are
+// numbered in order and the number is their SSE id, so a client reconnecting
+// with Last-Event-ID gets the events it missed from a bounded history.
 type Hub struct {
 	// Registered clients
 	clients map[*Client]bool
@@ -23,15 +30,36 @@ type Hub struct {
 
 	// Statistics
 	totalConnections int
+
+	// Number of the last broadcast event
+	seq uint64
+
+	// Last broadcast events for replay, oldest first
+	history     []*Event
+	historySize int
+}
+
+// Resume is what a registered client missed since its Last-Event-ID
+type Resume struct {
+	// LastEventID is the ID of the last event broadcast before the client
+	// registered
+	LastEventID string
+
+	// Missed are the events the client's filters accept, oldest first
+	Missed []*Event
+
+	// Truncated reports that the history no longer holds every missed event
+	Truncated bool
 }
 
-// NewHub creates a new SSE hub
-func NewHub(logger *slog.Logger, maxConnections int) *Hub {
+// NewHub creates a new SSE hub keeping the last historySize events for replay
+func NewHub(logger *slog.Logger, maxConnections, historySize int) *Hub {
 	return &Hub{
 		clients:          make(map[*Client]bool),
 		logger:           logger,
 		maxConnections:   maxConnections,
 		totalConnections: 0,
+		historySize:      historySize,
 	}
 }
 
@@ -51,8 +79,10 @@ func (h *Hub) Run(ctx context.Context) {
 	}
 }
 
-// Register registers a new SSE client
-func (h *Hub) Register(client *Client) {
+// Register registers a new SSE client. A client reconnecting with the ID of
+// the last event it received gets the events broadcast since; events
+// broadcast after Register returns are sent to the client as usual.
+func (h *Hub) Register(client *Client, lastEventID string) (*Resume, error) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
@@ -60,17 +90,36 @@ func (h *Hub) Register(client *Client) {
 	if len(h.clients) >= h.maxConnections {
 		h.logger.Warn("Maximum SSE connections reached", "maxConnections", h.maxConnections)
 		client.Close()
-		return
+		return nil, ErrTooManyConnections
 	}
 
 	h.clients[client] = true
 	h.totalConnections++
 
+	resume := &Resume{LastEventID: strconv.FormatUint(h.seq, 10)}
+	if lastEventID != "" {
+		// IDs that are not ours, or from before a restart, replay everything
+		last, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil || last > h.seq {
+			last, resume.Truncated = 0, true
+		}
+		oldest := h.seq + 1 - uint64(len(h.history))
+		resume.Truncated = resume.Truncated || last+1 < oldest
+		for _, event := range h.history {
+			if event.seq > last && client.Accepts(event) {
+				resume.Missed = append(resume.Missed, event)
+			}
+		}
+	}
+
 	h.logger.Info("SSE client registered",
 		"clientId", client.ID,
+		"lastEventId", lastEventID,
+		"missed", len(resume.Missed),
 		"totalClients", len(h.clients),
 		"totalConnections", h.totalConnections,
 	)
+	return resume, nil
 }
 
 // Unregister unregisters an SSE client
@@ -89,50 +138,52 @@ func (h *Hub) Unregister(client *Client) {
 	}
 }
 
-// BroadcastEvent broadcasts an event to all connected clients
+// BroadcastEvent broadcasts an event to all connected clients whose filters
+// accept it
 func (h *Hub) BroadcastEvent(eventType string, data interface{}) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-
-	event := &Event{
+	h.broadcast(&Event{
 		Type:      eventType,
 		Data:      data,
 		Timestamp: time.Now(),
-	}
-
-	for client := range h.clients {
-		select {
-		case client.send <- event:
-		default:
-			// Client channel is full, remove client
-			h.logger.Warn("SSE client channel full, removing client", "clientId", client.ID)
-			go h.Unregister(client)
-		}
-	}
+	})
 }
 
 // BroadcastEventToTopic broadcasts an event to clients subscribed to a specific topic
 func (h *Hub) BroadcastEventToTopic(topic, eventType string, data interface{}) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-
-	event := &Event{
+	h.broadcast(&Event{
 		Type:      eventType,
 		Topic:     topic,
 		Data:      data,
 		Timestamp: time.Now(),
+	})
+}
+
+// broadcast numbers an event, keeps it for replay and sends it to the
+// clients accepting it
+func (h *Hub) broadcast(event *Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	event.seq = h.seq
+	event.ID = strconv.FormatUint(h.seq, 10)
+	if h.historySize > 0 {
+		h.history = append(h.history, event)
+		if len(h.history) > h.historySize {
+			h.history = h.history[len(h.history)-h.historySize:]
+		}
 	}
 
 	for client := range h.clients {
-		// Check if client is subscribed to this topic
-		if client.IsSubscribedToTopic(topic) {
-			select {
-			case client.send <- event:
-			default:
-				// Client channel is full, remove client
-				h.logger.Warn("SSE client channel full, removing client", "clientId", client.ID)
-				go h.Unregister(client)
-			}
+		if !client.Accepts(event) {
+			continue
+		}
+		select {
+		case client.send <- event:
+		default:
+			// Client channel is full, remove client
+			h.logger.Warn("SSE client channel full, removing client", "clientId", client.ID)
+			go h.Unregister(client)
 		}
 	}
 }
@@ -151,6 +202,13 @@ func (h *Hub) GetTotalConnections() int {
 	return h.totalConnections
 }
 
+// GetHistorySize returns the number of events kept for replay
+func (h *Hub) GetHistorySize() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.history)
+}
+
 // GetClients returns all connected clients
 func (h *Hub) GetClients() map[*Client]bool {
 	h.mu.RLock()
@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"log/slog"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/Skpow1234/Peervault/internal/api/httpguard"
 	"github.com/Skpow1234/Peervault/internal/app/fileserver"
 )
 
@@ -19,6 +21,7 @@ type Server struct {
 	hub        *Hub
 	mu         sync.RWMutex
 	startTime  time.Time
+	guard      http.Handler
 }
 
 // Config holds the configuration for the SSE server
@@ -31,6 +34,11 @@ type Config struct {
 	WriteTimeout      time.Duration
 	KeepAliveInterval time.Duration
 	MaxConnections    int
+	// HistorySize is the number of events kept for clients resuming with
+	// Last-Event-ID; 0 disables replay
+	HistorySize int
+	// Security sets the CSRF protection and security headers of responses
+	Security httpguard.Policy
 }
 
 // DefaultConfig returns the default configuration
@@ -40,10 +48,12 @@ func DefaultConfig() *Config {
 		Host:              "localhost",
 		AllowedOrigins:    []string{"*"},
 		EnableCORS:        true,
+		Security:          httpguard.DefaultPolicy(),
 		ReadTimeout:       30 * time.Second,
 		WriteTimeout:      30 * time.Second,
 		KeepAliveInterval: 30 * time.Second,
 		MaxConnections:    1000,
+		HistorySize:       1000,
 	}
 }
 
@@ -54,7 +64,7 @@ func NewServer(fileserver *fileserver.Server, config *Config, logger *slog.Logge
 	}
 
 	// Create SSE hub
-	hub := NewHub(logger, config.MaxConnections)
+	hub := NewHub(logger, config.MaxConnections, config.HistorySize)
 
 	server := &Server{
 		fileserver: fileserver,
@@ -63,27 +73,34 @@ func NewServer(fileserver *fileserver.Server, config *Config, logger *slog.Logge
 		hub:        hub,
 		startTime:  time.Now(),
 	}
+	server.guard = httpguard.Middleware(server.guardConfig(), http.HandlerFunc(server.route))
 
 	// Start the SSE hub
 	go hub.Run(context.Background())
 
+	if fileserver != nil {
+		go server.forwardEvents()
+	}
+
 	return server
 }
 
-// ServeHTTP implements the http.Handler interface
-func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Add CORS headers if enabled
-	if s.config.EnableCORS {
-		s.addCORSHeaders(w, r)
+// forwardEvents broadcasts the file events of the node, such as conflicts
+// between versions written concurrently, with the file or document as topic
+func (s *Server) forwardEvents() {
+	events, _ := s.fileserver.Events.Subscribe(64)
+	for e := range events {
+		s.hub.BroadcastEventToTopic(e.Key, e.Type, e)
 	}
+}
 
-	// Handle preflight requests
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
+// ServeHTTP implements the http.Handler interface
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.guard.ServeHTTP(w, r)
+}
 
-	// Route requests based on path
+// route serves requests that passed the guard, based on their path
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
 	switch r.URL.Path {
 	case "/sse":
 		s.handleSSE(w, r)
@@ -93,60 +110,102 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		s.handleMetrics(w, r)
 	case "/sse/status":
 		s.handleStatus(w, r)
+	case "/sse/topics":
+		s.handleTopics(w, r)
+	case "/sse/topics/ack":
+		s.handleTopicAck(w, r)
 	default:
 		http.NotFound(w, r)
 	}
 }
 
-// addCORSHeaders adds CORS headers to the response
-func (s *Server) addCORSHeaders(w http.ResponseWriter, r *http.Request) {
-	origin := r.Header.Get("Origin")
-
-	// Check if origin is allowed
-	allowed := false
-	for _, allowedOrigin := range s.config.AllowedOrigins {
-		if allowedOrigin == "*" || allowedOrigin == origin {
-			allowed = true
-			break
-		}
+// guardConfig returns the CORS, CSRF and security header settings; with
+// CORS disabled no other origin is allowed
+func (s *Server) guardConfig() *httpguard.Config {
+	config := &httpguard.Config{
+		Policy:         s.config.Security,
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Content-Type", "Authorization", "Cache-Control", "Last-Event-ID"},
 	}
-
-	if allowed {
-		w.Header().Set("Access-Control-Allow-Origin", origin)
+	if s.config.EnableCORS {
+		config.AllowedOrigins = s.config.AllowedOrigins
 	}
-
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	return config
 }
 
-// handleSSE handles Server-Sent Events connections
+// handleSSE handles Server-Sent Events connections. The topic and event
+// query parameters, comma separated or repeated, filter the events of the
+// connection; patterns ending in "*" match by prefix. Clients resuming with
+// a Last-Event-ID header, or lastEventId parameter, first get the events
+// they missed.
 func (s *Server) handleSSE(w http.ResponseWriter, r *http.Request) {
-	// Set SSE headers
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Cache-Control")
-
 	// Create SSE client
 	client := NewClient(w, r, s.logger)
+	query := r.URL.Query()
+	for _, topic := range splitParams(query["topic"]) {
+		client.SubscribeToTopic(topic)
+	}
+	client.FilterEventTypes(splitParams(query["event"])...)
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = query.Get("lastEventId")
+	}
 
 	// Register client with hub
-	s.hub.Register(client)
+	resume, err := s.hub.Register(client, lastEventID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	defer s.hub.Unregister(client)
 
-	// Send initial connection event
-	client.SendEvent("connected", map[string]interface{}{
-		"message":   "Connected to PeerVault SSE",
-		"timestamp": time.Now().UTC(),
-		"clientId":  client.ID,
-	})
+	// Set SSE headers
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// Send the connection event and the missed events before the events
+	// queued since registering. Without missed events, the client resumes
+	// after the connection event.
+	connected := &Event{
+		Type: "connected",
+		Data: map[string]interface{}{
+			"message":   "Connected to PeerVault SSE",
+			"timestamp": time.Now().UTC(),
+			"clientId":  client.ID,
+			"replayed":  len(resume.Missed),
+			"truncated": resume.Truncated,
+		},
+		Timestamp: time.Now(),
+	}
+	if len(resume.Missed) == 0 {
+		connected.ID = resume.LastEventID
+	}
+	for _, event := range append([]*Event{connected}, resume.Missed...) {
+		if err := client.writeEvent(event); err != nil {
+			s.logger.Error("Failed to write SSE event", "error", err, "clientId", client.ID)
+			return
+		}
+	}
 
 	// Handle client connection
 	client.Handle()
 }
 
+// splitParams splits comma separated query parameter values
+func splitParams(values []string) []string {
+	var params []string
+	for _, value := range values {
+		for _, param := range strings.Split(value, ",") {
+			if param = strings.TrimSpace(param); param != "" {
+				params = append(params, param)
+			}
+		}
+	}
+	return params
+}
+
 // handleHealth handles health check requests
 func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
 	health := map[string]interface{}{
@@ -200,11 +259,14 @@ func (s *Server) handleStatus(w http.ResponseWriter, _ *http.Request) {
 		},
 		"sse": map[string]interface{}{
 			"active_connections": s.hub.GetActiveConnections(),
+			"history_size":       s.hub.GetHistorySize(),
 			"endpoints": []string{
 				"/sse",
 				"/sse/health",
 				"/sse/metrics",
 				"/sse/status",
+				"/sse/topics",
+				"/sse/topics/ack",
 			},
 		},
 		"timestamp": time.Now().UTC(),
@@ -0,0 +1,189 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/pubsub"
+	"github.com/Skpow1234/Peervault/internal/storage"
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// received is an event as a client reads it
+type received struct {
+	id, event string
+	data      map[string]any
+}
+
+// stream connects to the server and returns its events as they arrive
+func stream(t *testing.T, url, lastEventID string) <-chan received {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	events := make(chan received, 16)
+	go func() {
+		defer resp.Body.Close()
+		r := bufio.NewReader(resp.Body)
+		var e received
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			field, value, _ := strings.Cut(strings.TrimSuffix(line, "\n"), ": ")
+			switch field {
+			case "id":
+				e.id = value
+			case "event":
+				e.event = value
+			case "data":
+				_ = json.Unmarshal([]byte(value), &e.data)
+			case "":
+				events <- e
+				e = received{}
+			}
+		}
+	}()
+	return events
+}
+
+func next(t *testing.T, events <-chan received) received {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return received{}
+	}
+}
+
+// waitForClients waits until the hub has registered n clients
+func waitForClients(t *testing.T, s *Server, n int) {
+	require.Eventually(t, func() bool { return s.GetActiveConnections() == n }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSSEFilters(t *testing.T) {
+	s := NewServer(nil, DefaultConfig(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	events := stream(t, srv.URL+"/sse?topic=docs/*,logs/today&event=file.conflict", "")
+	assert.Equal(t, "connected", next(t, events).event)
+	waitForClients(t, s, 1)
+
+	s.BroadcastEventToTopic("docs/a.txt", "document.updated", map[string]any{"n": 1})
+	s.BroadcastEventToTopic("photos/b.jpg", "file.conflict", map[string]any{"n": 2})
+	s.BroadcastEvent("file.conflict", map[string]any{"n": 3})
+	s.BroadcastEventToTopic("docs/a.txt", "file.conflict", map[string]any{"n": 4})
+	s.BroadcastEventToTopic("logs/today", "file.conflict", map[string]any{"n": 5})
+
+	e := next(t, events)
+	assert.Equal(t, received{id: "4", event: "file.conflict", data: map[string]any{"n": 4.0}}, e)
+	assert.Equal(t, "5", next(t, events).id)
+}
+
+func TestSSEResume(t *testing.T) {
+	config := DefaultConfig()
+	config.HistorySize = 3
+	s := NewServer(nil, config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	first := stream(t, srv.URL+"/sse", "")
+	connected := next(t, first)
+	assert.Equal(t, "0", connected.id, "new clients resume after the connection event")
+	waitForClients(t, s, 1)
+	for n := 1; n <= 5; n++ {
+		s.BroadcastEvent("tick", map[string]any{"n": n})
+	}
+	for n := 1; n <= 5; n++ {
+		assert.Equal(t, float64(n), next(t, first).data["n"])
+	}
+
+	// Events 4 and 5 are still in the history
+	resumed := stream(t, srv.URL+"/sse", "3")
+	connected = next(t, resumed)
+	assert.Equal(t, "", connected.id)
+	assert.Equal(t, 2.0, connected.data["replayed"])
+	assert.Equal(t, false, connected.data["truncated"])
+	assert.Equal(t, "4", next(t, resumed).id)
+	assert.Equal(t, "5", next(t, resumed).id)
+	waitForClients(t, s, 2)
+	s.BroadcastEvent("tick", map[string]any{"n": 6})
+	assert.Equal(t, "6", next(t, resumed).id)
+
+	// Event 2 fell out of the history
+	resumed = stream(t, srv.URL+"/sse?lastEventId=1", "")
+	connected = next(t, resumed)
+	assert.Equal(t, 3.0, connected.data["replayed"])
+	assert.Equal(t, true, connected.data["truncated"])
+	assert.Equal(t, "4", next(t, resumed).id)
+
+	// Nothing was missed
+	resumed = stream(t, srv.URL+"/sse", "6")
+	connected = next(t, resumed)
+	assert.Equal(t, "6", connected.id)
+	assert.Equal(t, 0.0, connected.data["replayed"])
+}
+
+func TestSSETopics(t *testing.T) {
+	t.Chdir(t.TempDir())
+	node := fileserver.New(fileserver.Options{
+		ID:                "node-1",
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		Topics:            &pubsub.Config{Prefixes: []string{"durable/"}},
+	})
+	srv := httptest.NewServer(NewServer(node, DefaultConfig(), slog.New(slog.NewTextHandler(io.Discard, nil))))
+	t.Cleanup(srv.Close)
+
+	post := func(path string, body string) *http.Response {
+		resp, err := http.Post(srv.URL+path, "text/plain", strings.NewReader(body))
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+	assert.Equal(t, http.StatusBadRequest, post("/sse/topics?topic=live/chat", "x").StatusCode)
+	for _, payload := range []string{"a", "b", "c"} {
+		assert.Equal(t, http.StatusCreated, post("/sse/topics?topic=durable/chat", payload).StatusCode)
+	}
+
+	events := stream(t, srv.URL+"/sse/topics?topic=durable/chat&consumer=ui&offset=1", "")
+	e := next(t, events)
+	assert.Equal(t, "message", e.event)
+	assert.Equal(t, "1", e.id)
+	assert.Equal(t, "durable/chat", e.data["topic"])
+	assert.Equal(t, "Yg==", e.data["payload"], "payloads are base64")
+	assert.Equal(t, "2", next(t, events).id)
+
+	// Published over the node's other APIs
+	_, err := node.Topics().Publish(context.Background(), "durable/chat", []byte("d"))
+	require.NoError(t, err)
+	assert.Equal(t, "3", next(t, events).id)
+
+	// The group resumes after what it acknowledged, and clients resuming
+	// with Last-Event-ID after what they received
+	assert.Equal(t, http.StatusNoContent, post("/sse/topics/ack?topic=durable/chat&consumer=ui&offset=2", "").StatusCode)
+	assert.Equal(t, "3", next(t, stream(t, srv.URL+"/sse/topics?topic=durable/chat&consumer=ui", "")).id)
+	assert.Equal(t, "2", next(t, stream(t, srv.URL+"/sse/topics?topic=durable/chat", "1")).id)
+}
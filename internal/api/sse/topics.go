@@ -0,0 +1,174 @@
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/pubsub"
+	"github.com/Skpow1234/Peervault/internal/streamlog"
+)
+
+// The durable topics of the node, see fileserver.Server.Topics, are served
+// on /sse/topics:
+//
+//	GET  /sse/topics?topic=T[&consumer=G][&offset=N]  streams the messages of T
+//	POST /sse/topics?topic=T                          publishes the request body to T
+//	POST /sse/topics/ack?topic=T&consumer=G&offset=N  acknowledges the messages of T up to N
+//
+// Each message is a "message" event whose ID is its offset. A stream starts
+// at offset; otherwise after the Last-Event-ID the client resumes with, or
+// where its consumer group resumes. Groups resume after the last message
+// they acknowledged, so messages are delivered at least once.
+
+// durableBatch is the most messages read from a log at once
+const durableBatch = 100
+
+func (s *Server) topics(w http.ResponseWriter) *pubsub.Topics {
+	var topics *pubsub.Topics
+	if s.fileserver != nil {
+		topics = s.fileserver.Topics()
+	}
+	if topics == nil {
+		http.Error(w, "Durable topics are not enabled", http.StatusNotFound)
+	}
+	return topics
+}
+
+// handleTopics streams or publishes the messages of a durable topic
+func (s *Server) handleTopics(w http.ResponseWriter, r *http.Request) {
+	topics := s.topics(w)
+	if topics == nil {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		s.streamTopic(w, r, topics)
+	case http.MethodPost:
+		s.publishTopic(w, r, topics)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) streamTopic(w http.ResponseWriter, r *http.Request, topics *pubsub.Topics) {
+	query := r.URL.Query()
+	offset := pubsub.Resume
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 63)
+		if err != nil {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = int64(n)
+	} else if id := r.Header.Get("Last-Event-ID"); id != "" {
+		if n, err := strconv.ParseUint(id, 10, 63); err == nil {
+			offset = int64(n) + 1
+		}
+	}
+	sub, err := topics.Subscribe(r.Context(), query.Get("topic"), query.Get("consumer"), offset)
+	if err != nil {
+		writeTopicError(w, err)
+		return
+	}
+
+	// The stream outlasts the server's write timeout
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	_ = rc.Flush()
+
+	keepAlive := s.config.KeepAliveInterval
+	if keepAlive <= 0 {
+		keepAlive = DefaultConfig().KeepAliveInterval
+	}
+	client := NewClient(w, r, s.logger)
+	defer client.Close()
+	for {
+		wait, cancel := context.WithTimeout(r.Context(), keepAlive)
+		messages, err := sub.Next(wait, durableBatch)
+		cancel()
+		if r.Context().Err() != nil {
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			_ = rc.Flush()
+			continue
+		}
+		if err != nil {
+			s.logger.Error("Failed to read durable topic", "error", err, "topic", sub.Topic())
+			return
+		}
+		for _, m := range messages {
+			event := &Event{Type: "message", Topic: m.Topic, Data: m, Timestamp: m.Time, ID: strconv.FormatUint(m.Offset, 10)}
+			if err := client.writeEvent(event); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) publishTopic(w http.ResponseWriter, r *http.Request, topics *pubsub.Topics) {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, streamlog.MaxRecordSize))
+	if err != nil {
+		http.Error(w, "Message too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	message, err := topics.Publish(r.Context(), r.URL.Query().Get("topic"), payload)
+	if err != nil {
+		writeTopicError(w, err)
+		return
+	}
+	message.Payload = nil
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(message); err != nil {
+		s.logger.Error("Failed to encode publish response", "error", err)
+	}
+}
+
+// handleTopicAck acknowledges the messages of a durable topic for a
+// consumer group
+func (s *Server) handleTopicAck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	topics := s.topics(w)
+	if topics == nil {
+		return
+	}
+	query := r.URL.Query()
+	offset, err := strconv.ParseUint(query.Get("offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+	if err := topics.Ack(r.Context(), query.Get("topic"), query.Get("consumer"), offset); err != nil {
+		writeTopicError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeTopicError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, pubsub.ErrNotDurable), errors.Is(err, pubsub.ErrNoGroup):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, streamlog.ErrTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,150 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/messaging"
+)
+
+// The RPC protocol also sends application messages to channels of other
+// nodes and receives those sent to this one, see fileserver.Options.Messaging:
+//
+//	message.send      {"node": "<node ID>", "channel": "jobs", "payload": "<base64>", "ttl": 3600}
+//	message.subscribe {"channel": "jobs"}
+//	message.receipts  {}
+//	message.status    {"id": "<message ID>"}
+//
+// message.subscribe sends each message queued on the channel as a message
+// notification, and message.receipts each receipt of the messages this node
+// sent as a message.receipt notification; both end with unsubscribe. A
+// message goes to one subscriber of its channel, even across connections.
+
+type rpcMessageParams struct {
+	Node    string `json:"node,omitempty"`
+	Channel string `json:"channel,omitempty"`
+	Payload []byte `json:"payload,omitempty"`
+	// TTL is how many seconds the message waits to be delivered; zero uses
+	// messaging.DefaultTTL
+	TTL int64  `json:"ttl,omitempty"`
+	ID  string `json:"id,omitempty"`
+}
+
+// mailbox returns the mailbox of the node
+func (c *rpcConn) mailbox() (*messaging.Mailbox, error) {
+	if err := c.requireNode(); err != nil {
+		return nil, err
+	}
+	mb := c.handler.node.Messaging
+	if mb == nil {
+		return nil, &RPCError{Code: rpcServerError, Message: "messaging is not enabled"}
+	}
+	return mb, nil
+}
+
+// messageError reports mistakes of the client as invalid params
+func messageError(err error) error {
+	if errors.Is(err, messaging.ErrInvalid) || errors.Is(err, messaging.ErrNotFound) {
+		return invalidParams("%s", err.Error())
+	}
+	return err
+}
+
+func (c *rpcConn) sendMessage(id json.RawMessage, p rpcMessageParams) error {
+	mb, err := c.mailbox()
+	if err != nil {
+		return err
+	}
+	if p.TTL < 0 {
+		return invalidParams("ttl must not be negative")
+	}
+	d, err := mb.Send(p.Node, p.Channel, p.Payload, time.Duration(p.TTL)*time.Second)
+	if err != nil {
+		return messageError(err)
+	}
+	c.reply(id, d, nil)
+	return nil
+}
+
+func (c *rpcConn) messageStatus(id json.RawMessage, p rpcMessageParams) error {
+	mb, err := c.mailbox()
+	if err != nil {
+		return err
+	}
+	d, err := mb.Status(p.ID)
+	if err != nil {
+		return messageError(err)
+	}
+	c.reply(id, d, nil)
+	return nil
+}
+
+// subscription registers a subscription of the connection, returning its
+// name and the context it runs in
+func (c *rpcConn) subscription() (string, context.Context) {
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextSubscription++
+	name := fmt.Sprintf("sub-%d", c.nextSubscription)
+	c.subscriptions[name] = cancel
+	return name, ctx
+}
+
+// subscribeMessages sends the messages queued on a channel as message
+// notifications until the subscription or connection ends
+func (c *rpcConn) subscribeMessages(id json.RawMessage, p rpcMessageParams) error {
+	mb, err := c.mailbox()
+	if err != nil {
+		return err
+	}
+	if err := messaging.CheckChannel(p.Channel); err != nil {
+		return messageError(err)
+	}
+
+	subscription, ctx := c.subscription()
+	c.reply(id, map[string]string{"subscription": subscription}, nil)
+	c.async(func() {
+		for {
+			m, err := mb.Receive(ctx, p.Channel)
+			if err != nil {
+				return
+			}
+			c.notify("message", struct {
+				Subscription string            `json:"subscription"`
+				Message      messaging.Message `json:"message"`
+			}{subscription, m})
+		}
+	})
+	return nil
+}
+
+// subscribeReceipts sends the receipts of the messages this node sent as
+// message.receipt notifications until the subscription or connection ends
+func (c *rpcConn) subscribeReceipts(id json.RawMessage) error {
+	mb, err := c.mailbox()
+	if err != nil {
+		return err
+	}
+	receipts, stop := mb.Receipts(64)
+	subscription, ctx := c.subscription()
+	c.reply(id, map[string]string{"subscription": subscription}, nil)
+	c.async(func() {
+		defer stop()
+		for {
+			select {
+			case r := <-receipts:
+				c.notify("message.receipt", struct {
+					Subscription string            `json:"subscription"`
+					Receipt      messaging.Receipt `json:"receipt"`
+				}{subscription, r})
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	return nil
+}
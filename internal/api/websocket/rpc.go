@@ -0,0 +1,802 @@
+package websocket
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/events"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+	internalws "github.com/Skpow1234/Peervault/internal/websocket"
+	"github.com/gorilla/websocket"
+)
+
+// The RPC protocol served on /ws/rpc frames commands JSON-RPC 2.0 style in
+// text messages:
+//
+//	{"jsonrpc": "2.0", "id": 1, "method": "list", "params": {"prefix": "docs/"}}
+//	{"jsonrpc": "2.0", "id": 1, "result": {"files": [...]}}
+//
+// File content travels in binary messages: a 4 byte big-endian stream ID,
+// chosen by the client in store and get, followed by a chunk of the file.
+// The receiver of a stream grants the sender credit in bytes with
+// stream.credit notifications; a sender never has more bytes in flight than
+// it was granted. Downloads end with an empty chunk.
+const (
+	// Error codes of JSON-RPC 2.0
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+	// rpcTokenExpired fails watches whose resume token the node no longer
+	// holds; the client lists the keys and watches again
+	rpcTokenExpired = -32001
+
+	// rpcChunkSize is the largest chunk of a download
+	rpcChunkSize = 32 << 10
+
+	// rpcMaxTextMessage limits the size of a command
+	rpcMaxTextMessage = 1 << 20
+)
+
+// RPCError is the error of a failed command
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string { return e.Message }
+
+// rpcMessage is a request, response or notification
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// RPCFile describes a stored file
+type RPCFile struct {
+	Key       string    `json:"key"`
+	Size      int64     `json:"size"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+type rpcStoreParams struct {
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+	Stream uint32 `json:"stream"`
+}
+
+type rpcGetParams struct {
+	Key    string `json:"key"`
+	Stream uint32 `json:"stream"`
+	// Window is the initial credit of the download; the server's stream
+	// window when zero
+	Window int64 `json:"window,omitempty"`
+}
+
+type rpcKeyParams struct {
+	Key    string `json:"key"`
+	Prefix string `json:"prefix,omitempty"`
+}
+
+type rpcSubscribeParams struct {
+	// Topic selects the key of the file or document; a trailing "*"
+	// matches by prefix
+	Topic  string   `json:"topic,omitempty"`
+	Events []string `json:"events,omitempty"`
+}
+
+type rpcSubscriptionParams struct {
+	Subscription string `json:"subscription"`
+}
+
+type rpcStreamParams struct {
+	Stream uint32 `json:"stream"`
+	Bytes  int64  `json:"bytes,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// rpcHandler upgrades connections to the RPC protocol
+type rpcHandler struct {
+	node *fileserver.Server
+	// files lists the files when the node keeps no metadata store
+	files  *metadata.Store
+	window int64
+	config *Config
+	logger *slog.Logger
+}
+
+func newRPCHandler(node *fileserver.Server, config *Config, logger *slog.Logger) *rpcHandler {
+	h := &rpcHandler{node: node, window: int64(config.StreamWindow), config: config, logger: logger}
+	if node != nil && node.Metadata != nil {
+		h.files = node.Metadata
+	} else {
+		h.files = metadata.NewStore(metadata.StoreOpts{})
+	}
+	return h
+}
+
+// ServeHTTP upgrades the connection and serves commands until it closes
+func (h *rpcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := internalws.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		h.logger.Error("Failed to upgrade RPC connection", "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &rpcConn{
+		handler:       h,
+		ws:            conn,
+		ctx:           ctx,
+		cancel:        cancel,
+		out:           make(chan rpcFrame, 64),
+		uploads:       make(map[uint32]*rpcUpload),
+		downloads:     make(map[uint32]*rpcDownload),
+		subscriptions: make(map[string]func()),
+	}
+	h.logger.Info("WebSocket RPC connection established", "remoteAddr", r.RemoteAddr)
+	go c.writePump()
+	c.readPump()
+}
+
+// rpcFrame is a message waiting to be written
+type rpcFrame struct {
+	messageType int
+	data        []byte
+}
+
+// rpcConn is one RPC connection. Reads happen on the handler's goroutine,
+// writes on writePump's; commands moving file content run on their own.
+type rpcConn struct {
+	handler *rpcHandler
+	ws      *websocket.Conn
+	ctx     context.Context
+	cancel  context.CancelFunc
+	out     chan rpcFrame
+
+	mu            sync.Mutex
+	uploads       map[uint32]*rpcUpload
+	downloads     map[uint32]*rpcDownload
+	subscriptions map[string]func()
+	// nextSubscription numbers the subscriptions of the connection
+	nextSubscription int
+	wg               sync.WaitGroup
+}
+
+func (c *rpcConn) readPump() {
+	defer func() {
+		c.cancel()
+		c.mu.Lock()
+		for _, u := range c.uploads {
+			u.fail(io.ErrUnexpectedEOF)
+		}
+		for _, d := range c.downloads {
+			d.cancel()
+		}
+		for _, stop := range c.subscriptions {
+			stop()
+		}
+		c.mu.Unlock()
+		c.wg.Wait()
+		_ = c.ws.Close()
+	}()
+
+	limit := max(c.handler.window+4, rpcMaxTextMessage)
+	c.ws.SetReadLimit(limit)
+	_ = c.ws.SetReadDeadline(time.Now().Add(c.handler.config.PongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(c.handler.config.PongWait))
+	})
+
+	for {
+		messageType, data, err := c.ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				c.handler.logger.Warn("WebSocket RPC connection failed", "error", err)
+			}
+			return
+		}
+		if messageType == websocket.BinaryMessage {
+			c.handleChunk(data)
+			continue
+		}
+
+		var msg rpcMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.send(rpcMessage{Error: &RPCError{Code: rpcParseError, Message: "invalid JSON"}})
+			continue
+		}
+		if msg.JSONRPC != "2.0" || msg.Method == "" {
+			c.reply(msg.ID, nil, &RPCError{Code: rpcInvalidRequest, Message: "requests need jsonrpc 2.0 and a method"})
+			continue
+		}
+		c.handle(msg)
+	}
+}
+
+// writePump writes queued frames and pings the client
+func (c *rpcConn) writePump() {
+	ticker := time.NewTicker(c.handler.config.PingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case frame := <-c.out:
+			_ = c.ws.SetWriteDeadline(time.Now().Add(c.handler.config.WriteTimeout))
+			if err := c.ws.WriteMessage(frame.messageType, frame.data); err != nil {
+				c.cancel()
+				_ = c.ws.Close()
+				return
+			}
+		case <-ticker.C:
+			_ = c.ws.SetWriteDeadline(time.Now().Add(c.handler.config.WriteTimeout))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.cancel()
+				_ = c.ws.Close()
+				return
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// queue hands a frame to writePump, waiting while it is busy
+func (c *rpcConn) queue(frame rpcFrame) bool {
+	select {
+	case c.out <- frame:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+func (c *rpcConn) send(msg rpcMessage) {
+	msg.JSONRPC = "2.0"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		c.handler.logger.Error("Failed to marshal RPC message", "error", err)
+		return
+	}
+	c.queue(rpcFrame{messageType: websocket.TextMessage, data: data})
+}
+
+// reply answers a request; notifications, which have no ID, get no answer
+func (c *rpcConn) reply(id json.RawMessage, result any, rpcErr *RPCError) {
+	if len(id) == 0 {
+		return
+	}
+	c.send(rpcMessage{ID: id, Result: result, Error: rpcErr})
+}
+
+func (c *rpcConn) notify(method string, params any) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		c.handler.logger.Error("Failed to marshal RPC notification", "error", err)
+		return
+	}
+	c.send(rpcMessage{Method: method, Params: data})
+}
+
+// async runs a command that moves file content on its own goroutine
+func (c *rpcConn) async(fn func()) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		fn()
+	}()
+}
+
+func (c *rpcConn) handle(msg rpcMessage) {
+	var err error
+	switch msg.Method {
+	case "store":
+		var p rpcStoreParams
+		if err = decodeParams(msg.Params, &p); err == nil {
+			err = c.store(msg.ID, p)
+		}
+	case "get":
+		var p rpcGetParams
+		if err = decodeParams(msg.Params, &p); err == nil {
+			err = c.get(msg.ID, p)
+		}
+	case "list":
+		var p rpcKeyParams
+		if err = decodeParams(msg.Params, &p); err == nil {
+			result := map[string]any{}
+			if c.handler.node != nil {
+				// Taken before listing, it watches the changes after it
+				result["token"] = c.handler.node.WatchToken()
+			}
+			result["files"] = c.list(p.Prefix)
+			c.reply(msg.ID, result, nil)
+		}
+	case "delete":
+		var p rpcKeyParams
+		if err = decodeParams(msg.Params, &p); err == nil {
+			err = c.delete(msg.ID, p.Key)
+		}
+	case "subscribe":
+		var p rpcSubscribeParams
+		if err = decodeParams(msg.Params, &p); err == nil {
+			err = c.subscribe(msg.ID, p)
+		}
+	case "unsubscribe":
+		var p rpcSubscriptionParams
+		if err = decodeParams(msg.Params, &p); err == nil {
+			err = c.unsubscribe(msg.ID, p.Subscription)
+		}
+	case "topic.publish":
+		var p rpcTopicParams
+		if err = decodeParams(msg.Params, &p); err == nil {
+			err = c.publishTopic(msg.ID, p)
+		}
+	case "topic.subscribe":
+		var p rpcTopicParams
+		if err = decodeParams(msg.Params, &p); err == nil {
+			err = c.subscribeTopic(msg.ID, p)
+		}
+	case "topic.ack":
+		var p rpcTopicParams
+		if err = decodeParams(msg.Params, &p); err == nil {
+			err = c.ackTopic(msg.ID, p)
+		}
+	case "message.send":
+		var p rpcMessageParams
+		if err = decodeParams(msg.Params, &p); err == nil {
+			err = c.sendMessage(msg.ID, p)
+		}
+	case "message.subscribe":
+		var p rpcMessageParams
+		if err = decodeParams(msg.Params, &p); err == nil {
+			err = c.subscribeMessages(msg.ID, p)
+		}
+	case "message.receipts":
+		err = c.subscribeReceipts(msg.ID)
+	case "message.status":
+		var p rpcMessageParams
+		if err = decodeParams(msg.Params, &p); err == nil {
+			err = c.messageStatus(msg.ID, p)
+		}
+	case "watch":
+		var p rpcWatchParams
+		if err = decodeParams(msg.Params, &p); err == nil {
+			err = c.watch(msg.ID, p)
+		}
+	case "stream.credit":
+		var p rpcStreamParams
+		if err = decodeParams(msg.Params, &p); err == nil {
+			err = c.credit(p)
+		}
+	case "stream.cancel":
+		var p rpcStreamParams
+		if err = decodeParams(msg.Params, &p); err == nil {
+			c.cancelStream(p.Stream)
+		}
+	default:
+		err = &RPCError{Code: rpcMethodNotFound, Message: "unknown method " + msg.Method}
+	}
+
+	if err != nil {
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &RPCError{Code: rpcServerError, Message: err.Error()}
+		}
+		c.reply(msg.ID, nil, rpcErr)
+	}
+}
+
+func decodeParams(raw json.RawMessage, v any) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &RPCError{Code: rpcInvalidParams, Message: "invalid params: " + err.Error()}
+	}
+	return nil
+}
+
+func invalidParams(format string, args ...any) error {
+	return &RPCError{Code: rpcInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+func (c *rpcConn) requireNode() error {
+	if c.handler.node == nil {
+		return &RPCError{Code: rpcServerError, Message: "no file server attached"}
+	}
+	return nil
+}
+
+// openStream checks that a stream ID is free; the caller holds c.mu
+func (c *rpcConn) openStream(stream uint32) error {
+	if stream == 0 {
+		return invalidParams("stream must be a non-zero 32-bit number")
+	}
+	if c.uploads[stream] != nil || c.downloads[stream] != nil {
+		return invalidParams("stream %d is in use", stream)
+	}
+	return nil
+}
+
+// store receives a file in binary frames and answers once the node stored
+// it
+func (c *rpcConn) store(id json.RawMessage, p rpcStoreParams) error {
+	if err := c.requireNode(); err != nil {
+		return err
+	}
+	if p.Key == "" || p.Size < 0 {
+		return invalidParams("store needs a key and a size")
+	}
+
+	c.mu.Lock()
+	if err := c.openStream(p.Stream); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	u := newRPCUpload(p.Size, c.handler.window)
+	c.uploads[p.Stream] = u
+	c.mu.Unlock()
+
+	u.granted = func(n int64) { c.notify("stream.credit", rpcStreamParams{Stream: p.Stream, Bytes: n}) }
+	if u.credit > 0 {
+		u.granted(u.credit)
+	}
+	c.async(func() {
+		err := c.handler.node.Store(c.ctx, p.Key, u)
+		c.mu.Lock()
+		delete(c.uploads, p.Stream)
+		c.mu.Unlock()
+		if err == nil {
+			err = u.failure()
+		}
+		if err != nil {
+			c.reply(id, nil, &RPCError{Code: rpcServerError, Message: err.Error()})
+			return
+		}
+		if c.handler.node.Metadata == nil {
+			_, _ = c.handler.files.Put(metadata.FileRecord{Key: p.Key, Size: p.Size})
+		}
+		c.reply(id, RPCFile{Key: p.Key, Size: p.Size, UpdatedAt: time.Now().UTC()}, nil)
+	})
+	return nil
+}
+
+// handleChunk hands a binary frame to its upload
+func (c *rpcConn) handleChunk(data []byte) {
+	if len(data) < 4 {
+		return
+	}
+	stream := binary.BigEndian.Uint32(data)
+	c.mu.Lock()
+	u := c.uploads[stream]
+	c.mu.Unlock()
+	if u == nil {
+		c.handler.logger.Debug("Chunk for unknown RPC stream", "stream", stream)
+		return
+	}
+	if err := u.push(data[4:]); err != nil {
+		c.notify("stream.cancel", rpcStreamParams{Stream: stream, Reason: err.Error()})
+	}
+}
+
+// get sends a file in binary frames as the client grants credit
+func (c *rpcConn) get(id json.RawMessage, p rpcGetParams) error {
+	if err := c.requireNode(); err != nil {
+		return err
+	}
+	if p.Key == "" {
+		return invalidParams("get needs a key")
+	}
+	if p.Window <= 0 {
+		p.Window = c.handler.window
+	}
+
+	c.mu.Lock()
+	if err := c.openStream(p.Stream); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	d := newRPCDownload(p.Window)
+	c.downloads[p.Stream] = d
+	c.mu.Unlock()
+
+	c.async(func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.downloads, p.Stream)
+			c.mu.Unlock()
+		}()
+		r, err := c.handler.node.Get(c.ctx, p.Key)
+		if err != nil {
+			c.reply(id, nil, &RPCError{Code: rpcServerError, Message: err.Error()})
+			return
+		}
+		file := RPCFile{Key: p.Key, Size: -1}
+		if rec, err := c.handler.files.Get(p.Key); err == nil {
+			file.Size, file.UpdatedAt = rec.Size, rec.UpdatedAt
+		}
+		c.reply(id, file, nil)
+
+		buf := make([]byte, rpcChunkSize)
+		for {
+			reserved, ok := d.take(int64(len(buf)))
+			if !ok {
+				return
+			}
+			n, err := r.Read(buf[:reserved])
+			if n > 0 {
+				frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+n), p.Stream)
+				if !c.queue(rpcFrame{messageType: websocket.BinaryMessage, data: append(frame, buf[:n]...)}) {
+					return
+				}
+			}
+			d.grant(int64(reserved - n))
+			if errors.Is(err, io.EOF) {
+				c.queue(rpcFrame{messageType: websocket.BinaryMessage, data: binary.BigEndian.AppendUint32(nil, p.Stream)})
+				return
+			}
+			if err != nil {
+				c.notify("stream.cancel", rpcStreamParams{Stream: p.Stream, Reason: err.Error()})
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (c *rpcConn) list(prefix string) []RPCFile {
+	files := []RPCFile{}
+	for _, rec := range c.handler.files.List() {
+		if strings.HasPrefix(rec.Key, prefix) {
+			files = append(files, RPCFile{Key: rec.Key, Size: rec.Size, UpdatedAt: rec.UpdatedAt})
+		}
+	}
+	return files
+}
+
+func (c *rpcConn) delete(id json.RawMessage, key string) error {
+	if err := c.requireNode(); err != nil {
+		return err
+	}
+	if key == "" {
+		return invalidParams("delete needs a key")
+	}
+	if err := c.handler.node.Delete(c.ctx, key); err != nil {
+		return err
+	}
+	if c.handler.node.Metadata == nil {
+		_, _ = c.handler.files.Delete(key)
+	}
+	c.reply(id, map[string]string{"key": key}, nil)
+	return nil
+}
+
+// subscribe forwards the node's file events as event notifications
+func (c *rpcConn) subscribe(id json.RawMessage, p rpcSubscribeParams) error {
+	if err := c.requireNode(); err != nil {
+		return err
+	}
+	ch, stop := c.handler.node.Events.Subscribe(64)
+	c.mu.Lock()
+	c.nextSubscription++
+	subscription := fmt.Sprintf("sub-%d", c.nextSubscription)
+	c.subscriptions[subscription] = stop
+	c.mu.Unlock()
+
+	c.reply(id, rpcSubscriptionParams{Subscription: subscription}, nil)
+	c.async(func() {
+		for e := range ch {
+			if !matchesTopic(p.Topic, e.Key) || (len(p.Events) > 0 && !slices.Contains(p.Events, e.Type)) {
+				continue
+			}
+			c.notify("event", struct {
+				Subscription string       `json:"subscription"`
+				Event        events.Event `json:"event"`
+			}{subscription, e})
+		}
+	})
+	return nil
+}
+
+func (c *rpcConn) unsubscribe(id json.RawMessage, subscription string) error {
+	c.mu.Lock()
+	stop, ok := c.subscriptions[subscription]
+	delete(c.subscriptions, subscription)
+	c.mu.Unlock()
+	if !ok {
+		return invalidParams("unknown subscription %q", subscription)
+	}
+	stop()
+	c.reply(id, rpcSubscriptionParams{Subscription: subscription}, nil)
+	return nil
+}
+
+// credit grants a download more bytes
+func (c *rpcConn) credit(p rpcStreamParams) error {
+	if p.Bytes <= 0 {
+		return invalidParams("credit must be positive")
+	}
+	c.mu.Lock()
+	d := c.downloads[p.Stream]
+	c.mu.Unlock()
+	if d != nil {
+		d.grant(p.Bytes)
+	}
+	return nil
+}
+
+// cancelStream stops an upload or download the client gave up on
+func (c *rpcConn) cancelStream(stream uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if u := c.uploads[stream]; u != nil {
+		u.fail(errors.New("upload canceled"))
+	}
+	if d := c.downloads[stream]; d != nil {
+		d.cancel()
+	}
+}
+
+func matchesTopic(pattern, topic string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(topic, prefix)
+	}
+	return pattern == "" || pattern == topic
+}
+
+// rpcUpload is the content of a store command, read by the node while the
+// connection appends the client's chunks. The client may send at most
+// credit bytes ahead of the node; the node's reads grant more.
+type rpcUpload struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	left   int64
+	credit int64
+	// consumed counts bytes read since the last grant
+	consumed int64
+	window   int64
+	err      error
+	granted  func(int64)
+}
+
+func newRPCUpload(size, window int64) *rpcUpload {
+	u := &rpcUpload{left: size, credit: min(size, window), window: window}
+	u.cond = sync.NewCond(&u.mu)
+	return u
+}
+
+// push appends a chunk, failing the upload when the client ignores its
+// credit or sends more than the size
+func (u *rpcUpload) push(chunk []byte) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.err != nil {
+		return u.err
+	}
+	switch {
+	case int64(len(chunk)) > u.credit:
+		u.err = errors.New("chunk exceeds the granted credit")
+	case int64(len(chunk)) > u.left:
+		u.err = errors.New("upload exceeds its size")
+	default:
+		u.buf = append(u.buf, chunk...)
+		u.credit -= int64(len(chunk))
+		u.left -= int64(len(chunk))
+	}
+	u.cond.Broadcast()
+	return u.err
+}
+
+func (u *rpcUpload) fail(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.err == nil {
+		u.err = err
+	}
+	u.cond.Broadcast()
+}
+
+func (u *rpcUpload) failure() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.err
+}
+
+// Read implements io.Reader for the node
+func (u *rpcUpload) Read(p []byte) (int, error) {
+	u.mu.Lock()
+	for len(u.buf) == 0 && u.left > 0 && u.err == nil {
+		u.cond.Wait()
+	}
+	if u.err != nil {
+		u.mu.Unlock()
+		return 0, u.err
+	}
+	if len(u.buf) == 0 {
+		u.mu.Unlock()
+		return 0, io.EOF
+	}
+	n := copy(p, u.buf)
+	u.buf = u.buf[n:]
+	u.consumed += int64(n)
+
+	// Grant credit in batches of half a window, and never more than the
+	// rest of the file
+	var grant int64
+	if u.consumed >= u.window/2 || u.consumed >= u.left-u.credit {
+		grant = min(u.consumed, u.left-u.credit)
+		u.consumed = 0
+	}
+	if grant > 0 {
+		u.credit += grant
+	}
+	u.mu.Unlock()
+
+	if grant > 0 {
+		u.granted(grant)
+	}
+	return n, nil
+}
+
+// rpcDownload tracks the credit the client granted a get command
+type rpcDownload struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	credit   int64
+	canceled bool
+}
+
+func newRPCDownload(window int64) *rpcDownload {
+	d := &rpcDownload{credit: window}
+	d.cond = sync.NewCond(&d.mu)
+	return d
+}
+
+// take waits for credit and reserves up to n bytes of it
+func (d *rpcDownload) take(n int64) (int, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for d.credit == 0 && !d.canceled {
+		d.cond.Wait()
+	}
+	if d.canceled {
+		return 0, false
+	}
+	n = min(n, d.credit)
+	d.credit -= n
+	return int(n), true
+}
+
+func (d *rpcDownload) grant(n int64) {
+	if n <= 0 {
+		return
+	}
+	d.mu.Lock()
+	d.credit += n
+	d.mu.Unlock()
+	d.cond.Broadcast()
+}
+
+func (d *rpcDownload) cancel() {
+	d.mu.Lock()
+	d.canceled = true
+	d.mu.Unlock()
+	d.cond.Broadcast()
+}
@@ -0,0 +1,368 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/events"
+	"github.com/Skpow1234/Peervault/internal/messaging"
+	"github.com/Skpow1234/Peervault/internal/pubsub"
+	"github.com/Skpow1234/Peervault/internal/storage"
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+	"github.com/Skpow1234/Peervault/internal/watch"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rpcClient speaks the RPC protocol in tests
+type rpcClient struct {
+	t  *testing.T
+	ws *websocket.Conn
+	id int
+}
+
+func dialRPC(t *testing.T, window int) (*rpcClient, *fileserver.Server) {
+	t.Chdir(t.TempDir())
+	node := fileserver.New(fileserver.Options{
+		ID:                "node-1",
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		Topics:            &pubsub.Config{Prefixes: []string{"durable/"}},
+		Messaging:         messaging.New(messaging.Options{}),
+	})
+	config := DefaultConfig()
+	config.StreamWindow = window
+	srv := httptest.NewServer(NewServer(node, config, slog.New(slog.NewTextHandler(io.Discard, nil))))
+	t.Cleanup(srv.Close)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/rpc", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { ws.Close() })
+	return &rpcClient{t: t, ws: ws}, node
+}
+
+// call sends a request and returns its ID
+func (c *rpcClient) call(method string, params any) int {
+	c.id++
+	c.notify(method, params, c.id)
+	return c.id
+}
+
+func (c *rpcClient) notify(method string, params any, id ...int) {
+	msg := map[string]any{"jsonrpc": "2.0", "method": method, "params": params}
+	if len(id) > 0 {
+		msg["id"] = id[0]
+	}
+	require.NoError(c.t, c.ws.WriteJSON(msg))
+}
+
+func (c *rpcClient) chunk(stream uint32, data []byte) {
+	require.NoError(c.t, c.ws.WriteMessage(websocket.BinaryMessage, append(binary.BigEndian.AppendUint32(nil, stream), data...)))
+}
+
+// rpcReply is a text or binary message from the server
+type rpcReply struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+	stream uint32
+	chunk  []byte
+}
+
+func (c *rpcClient) next() rpcReply {
+	require.NoError(c.t, c.ws.SetReadDeadline(time.Now().Add(5*time.Second)))
+	messageType, data, err := c.ws.ReadMessage()
+	require.NoError(c.t, err)
+	if messageType == websocket.BinaryMessage {
+		return rpcReply{stream: binary.BigEndian.Uint32(data), chunk: data[4:]}
+	}
+	var r rpcReply
+	require.NoError(c.t, json.Unmarshal(data, &r))
+	return r
+}
+
+func (c *rpcClient) credit(reply rpcReply) (uint32, int64) {
+	require.Equal(c.t, "stream.credit", reply.Method)
+	var p rpcStreamParams
+	require.NoError(c.t, json.Unmarshal(reply.Params, &p))
+	return p.Stream, p.Bytes
+}
+
+func TestRPCStoreAndGet(t *testing.T) {
+	c, _ := dialRPC(t, 8)
+	content := []byte("twenty bytes of data")
+
+	// The upload never runs ahead of its credit
+	id := c.call("store", map[string]any{"key": "docs/a.txt", "size": len(content), "stream": 7})
+	stream, credit := c.credit(c.next())
+	assert.Equal(t, uint32(7), stream)
+	assert.Equal(t, int64(8), credit)
+	sent := 0
+	for sent < len(content) {
+		for credit > 0 && sent < len(content) {
+			n := min(int(credit), 3, len(content)-sent)
+			c.chunk(7, content[sent:sent+n])
+			sent += n
+			credit -= int64(n)
+		}
+		if sent < len(content) {
+			_, more := c.credit(c.next())
+			credit += more
+		}
+	}
+	reply := c.next()
+	for reply.Method == "stream.credit" {
+		reply = c.next()
+	}
+	require.Nil(t, reply.Error)
+	assert.Equal(t, id, reply.ID)
+
+	id = c.call("list", map[string]any{"prefix": "docs/"})
+	reply = c.next()
+	assert.Equal(t, id, reply.ID)
+	var list struct{ Files []RPCFile }
+	require.NoError(t, json.Unmarshal(reply.Result, &list))
+	require.Len(t, list.Files, 1)
+	assert.Equal(t, "docs/a.txt", list.Files[0].Key)
+	assert.Equal(t, int64(len(content)), list.Files[0].Size)
+
+	// The download stops when the credit runs out
+	id = c.call("get", map[string]any{"key": "docs/a.txt", "stream": 9, "window": 5})
+	reply = c.next()
+	assert.Equal(t, id, reply.ID)
+	reply = c.next()
+	assert.Equal(t, uint32(9), reply.stream)
+	assert.Len(t, reply.chunk, 5)
+	got := bytes.NewBuffer(reply.chunk)
+	c.notify("stream.credit", map[string]any{"stream": 9, "bytes": 100})
+	for reply = c.next(); len(reply.chunk) > 0; reply = c.next() {
+		got.Write(reply.chunk)
+	}
+	assert.Equal(t, uint32(9), reply.stream, "an empty chunk ends the download")
+	assert.Equal(t, content, got.Bytes())
+}
+
+func TestRPCErrorsAndSubscriptions(t *testing.T) {
+	c, node := dialRPC(t, 1024)
+
+	c.call("rename", nil)
+	assert.Equal(t, rpcMethodNotFound, c.next().Error.Code)
+	c.call("store", map[string]any{"key": "a.txt", "size": 3})
+	assert.Equal(t, rpcInvalidParams, c.next().Error.Code, "streams must be non-zero")
+	c.call("get", map[string]any{"key": "missing.txt", "stream": 1})
+	assert.Equal(t, rpcServerError, c.next().Error.Code)
+
+	c.call("store", map[string]any{"key": "a.txt", "size": 3, "stream": 2})
+	c.next()
+	c.chunk(2, []byte("four"))
+	assert.Equal(t, "stream.cancel", c.next().Method, "the chunk is larger than the file")
+	assert.Equal(t, rpcServerError, c.next().Error.Code)
+
+	id := c.call("subscribe", map[string]any{"topic": "docs/*", "events": []string{events.FileConflict}})
+	reply := c.next()
+	assert.Equal(t, id, reply.ID)
+	var sub rpcSubscriptionParams
+	require.NoError(t, json.Unmarshal(reply.Result, &sub))
+
+	node.Events.Publish(events.Event{Type: events.FileConflict, Key: "photos/b.jpg"})
+	node.Events.Publish(events.Event{Type: events.DocumentUpdated, Key: "docs/c"})
+	node.Events.Publish(events.Event{Type: events.FileConflict, Key: "docs/c"})
+	reply = c.next()
+	assert.Equal(t, "event", reply.Method)
+	var e struct {
+		Subscription string
+		Event        events.Event
+	}
+	require.NoError(t, json.Unmarshal(reply.Params, &e))
+	assert.Equal(t, sub.Subscription, e.Subscription)
+	assert.Equal(t, "docs/c", e.Event.Key)
+	assert.Equal(t, events.FileConflict, e.Event.Type)
+
+	id = c.call("unsubscribe", sub)
+	assert.Equal(t, id, c.next().ID)
+	c.call("unsubscribe", sub)
+	assert.Equal(t, rpcInvalidParams, c.next().Error.Code)
+}
+
+func TestRPCTopics(t *testing.T) {
+	c, node := dialRPC(t, 1024)
+	ctx := context.Background()
+
+	c.call("topic.publish", map[string]any{"topic": "live/chat", "payload": []byte("x")})
+	assert.Equal(t, rpcInvalidParams, c.next().Error.Code, "only durable topics are kept")
+
+	// Published over another API before the group subscribes
+	_, err := node.Topics().Publish(ctx, "durable/orders", []byte("o0"))
+	require.NoError(t, err)
+
+	id := c.call("topic.subscribe", map[string]any{"topic": "durable/orders", "consumer": "billing", "offset": 0})
+	reply := c.next()
+	assert.Equal(t, id, reply.ID)
+	var sub rpcSubscriptionParams
+	require.NoError(t, json.Unmarshal(reply.Result, &sub))
+
+	message := func() pubsub.Message {
+		reply := c.next()
+		require.Equal(t, "topic.message", reply.Method)
+		var p struct {
+			Subscription string
+			Message      pubsub.Message
+		}
+		require.NoError(t, json.Unmarshal(reply.Params, &p))
+		assert.Equal(t, sub.Subscription, p.Subscription)
+		return p.Message
+	}
+	assert.Equal(t, "o0", string(message().Payload))
+
+	id = c.call("topic.publish", map[string]any{"topic": "durable/orders", "payload": []byte("o1")})
+	var published, delivered pubsub.Message
+	for range 2 {
+		reply := c.next()
+		if reply.ID == id {
+			require.NoError(t, json.Unmarshal(reply.Result, &published))
+			continue
+		}
+		var p struct{ Message pubsub.Message }
+		require.NoError(t, json.Unmarshal(reply.Params, &p))
+		delivered = p.Message
+	}
+	assert.Equal(t, uint64(1), published.Offset)
+	assert.Equal(t, "o1", string(delivered.Payload))
+
+	// Only the first message is acknowledged, so the group gets the second
+	// one again
+	id = c.call("topic.ack", map[string]any{"topic": "durable/orders", "consumer": "billing", "offset": 0})
+	assert.Equal(t, id, c.next().ID)
+	id = c.call("unsubscribe", sub)
+	assert.Equal(t, id, c.next().ID)
+
+	c.call("topic.subscribe", map[string]any{"topic": "durable/orders", "consumer": "billing"})
+	require.NoError(t, json.Unmarshal(c.next().Result, &sub))
+	again := message()
+	assert.Equal(t, uint64(1), again.Offset)
+	assert.Equal(t, "o1", string(again.Payload))
+}
+
+func TestRPCMessages(t *testing.T) {
+	c, node := dialRPC(t, 1024)
+	require.NoError(t, node.Start())
+	t.Cleanup(node.Stop)
+
+	c.call("message.subscribe", map[string]any{"channel": "bad channel"})
+	assert.Equal(t, rpcInvalidParams, c.next().Error.Code)
+
+	c.call("message.receipts", nil)
+	var receipts rpcSubscriptionParams
+	require.NoError(t, json.Unmarshal(c.next().Result, &receipts))
+	c.call("message.subscribe", map[string]any{"channel": "jobs"})
+	var jobs rpcSubscriptionParams
+	require.NoError(t, json.Unmarshal(c.next().Result, &jobs))
+
+	// Sent to this node, the message comes back to the subscriber
+	id := c.call("message.send", map[string]any{"node": "node-1", "channel": "jobs", "payload": []byte("job 1"), "ttl": 60})
+	var sent messaging.Delivery
+	var received messaging.Message
+	var statuses []messaging.Status
+	for range 4 {
+		reply := c.next()
+		switch reply.Method {
+		case "":
+			assert.Equal(t, id, reply.ID)
+			require.NoError(t, json.Unmarshal(reply.Result, &sent))
+		case "message":
+			var p struct {
+				Subscription string
+				Message      messaging.Message
+			}
+			require.NoError(t, json.Unmarshal(reply.Params, &p))
+			assert.Equal(t, jobs.Subscription, p.Subscription)
+			received = p.Message
+		case "message.receipt":
+			var p struct {
+				Subscription string
+				Receipt      messaging.Receipt
+			}
+			require.NoError(t, json.Unmarshal(reply.Params, &p))
+			assert.Equal(t, receipts.Subscription, p.Subscription)
+			statuses = append(statuses, p.Receipt.Status)
+		}
+	}
+	assert.Equal(t, "job 1", string(received.Payload))
+	assert.Equal(t, sent.ID, received.ID)
+	assert.Equal(t, []messaging.Status{messaging.Queued, messaging.Delivered}, statuses)
+	assert.Equal(t, time.Minute, sent.ExpiresAt.Sub(sent.SentAt))
+
+	c.call("message.status", map[string]any{"id": sent.ID})
+	var status messaging.Delivery
+	require.NoError(t, json.Unmarshal(c.next().Result, &status))
+	assert.Equal(t, messaging.Delivered, status.Status)
+	c.call("message.status", map[string]any{"id": "unknown"})
+	assert.Equal(t, rpcInvalidParams, c.next().Error.Code)
+
+	// A node that is not connected gets the message once it is
+	c.call("message.send", map[string]any{"node": "node-2", "channel": "jobs", "payload": []byte("job 2")})
+	require.NoError(t, json.Unmarshal(c.next().Result, &status))
+	assert.Equal(t, messaging.Pending, status.Status)
+}
+
+func TestRPCWatch(t *testing.T) {
+	c, node := dialRPC(t, 1024)
+	ctx := context.Background()
+
+	id := c.call("watch", map[string]any{"prefix": "docs/"})
+	reply := c.next()
+	assert.Equal(t, id, reply.ID)
+	var sub struct {
+		Subscription string
+		Token        string
+	}
+	require.NoError(t, json.Unmarshal(reply.Result, &sub))
+
+	event := func() watch.Event {
+		reply := c.next()
+		require.Equal(t, "watch", reply.Method)
+		var p struct {
+			Subscription string
+			Event        watch.Event
+		}
+		require.NoError(t, json.Unmarshal(reply.Params, &p))
+		assert.Equal(t, sub.Subscription, p.Subscription)
+		return p.Event
+	}
+	require.NoError(t, node.Store(ctx, "photos/a.jpg", strings.NewReader("a")))
+	require.NoError(t, node.Store(ctx, "docs/b.txt", strings.NewReader("b")))
+	created := event()
+	assert.Equal(t, watch.Created, created.Op)
+	assert.Equal(t, "docs/b.txt", created.Key)
+	require.NoError(t, node.Delete(ctx, "docs/b.txt"))
+	assert.Equal(t, watch.Deleted, event().Op)
+	require.NoError(t, node.Store(ctx, "docs/c.txt", strings.NewReader("c")))
+	assert.Equal(t, "docs/c.txt", event().Key)
+
+	id = c.call("unsubscribe", map[string]any{"subscription": sub.Subscription})
+	assert.Equal(t, id, c.next().ID)
+
+	// A new watch resumes after the first change
+	c.call("watch", map[string]any{"prefix": "docs/", "token": created.Token})
+	require.NoError(t, json.Unmarshal(c.next().Result, &sub))
+	assert.Equal(t, watch.Deleted, event().Op)
+	assert.Equal(t, "docs/c.txt", event().Key)
+
+	c.call("watch", map[string]any{"token": "garbage"})
+	assert.Equal(t, rpcInvalidParams, c.next().Error.Code)
+	c.call("watch", map[string]any{"token": watch.New(0).Token()})
+	assert.Equal(t, rpcTokenExpired, c.next().Error.Code)
+}
@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/Skpow1234/Peervault/internal/api/httpguard"
 	"github.com/Skpow1234/Peervault/internal/app/fileserver"
 	"github.com/Skpow1234/Peervault/internal/websocket"
 )
@@ -19,8 +20,10 @@ type Server struct {
 	logger     *slog.Logger
 	hub        *websocket.Hub
 	handler    *websocket.Handler
+	rpc        *rpcHandler
 	mu         sync.RWMutex
 	startTime  time.Time
+	guard      http.Handler
 }
 
 // Config holds the configuration for the WebSocket server
@@ -33,6 +36,11 @@ type Config struct {
 	WriteTimeout   time.Duration
 	PingPeriod     time.Duration
 	PongWait       time.Duration
+	// StreamWindow is the credit in bytes each file transfer of the RPC
+	// protocol starts with
+	StreamWindow int
+	// Security sets the CSRF protection and security headers of responses
+	Security httpguard.Policy
 }
 
 // DefaultConfig returns the default configuration
@@ -42,10 +50,12 @@ func DefaultConfig() *Config {
 		Host:           "localhost",
 		AllowedOrigins: []string{"*"},
 		EnableCORS:     true,
+		Security:       httpguard.DefaultPolicy(),
 		ReadTimeout:    30 * time.Second,
 		WriteTimeout:   30 * time.Second,
 		PingPeriod:     54 * time.Second,
 		PongWait:       60 * time.Second,
+		StreamWindow:   256 << 10,
 	}
 }
 
@@ -65,8 +75,10 @@ func NewServer(fileserver *fileserver.Server, config *Config, logger *slog.Logge
 		logger:     logger,
 		hub:        hub,
 		handler:    handler,
+		rpc:        newRPCHandler(fileserver, config, logger),
 		startTime:  time.Now(),
 	}
+	server.guard = httpguard.Middleware(server.guardConfig(), http.HandlerFunc(server.route))
 
 	// Start the WebSocket hub
 	go hub.Run(context.Background())
@@ -76,21 +88,16 @@ func NewServer(fileserver *fileserver.Server, config *Config, logger *slog.Logge
 
 // ServeHTTP implements the http.Handler interface
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Add CORS headers if enabled
-	if s.config.EnableCORS {
-		s.addCORSHeaders(w, r)
-	}
-
-	// Handle preflight requests
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
+	s.guard.ServeHTTP(w, r)
+}
 
-	// Route requests based on path
+// route serves requests that passed the guard, based on their path
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
 	switch r.URL.Path {
 	case "/ws":
 		s.handler.ServeHTTP(w, r)
+	case "/ws/rpc":
+		s.rpc.ServeHTTP(w, r)
 	case "/ws/health":
 		s.handleHealth(w, r)
 	case "/ws/metrics":
@@ -102,26 +109,18 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// addCORSHeaders adds CORS headers to the response
-func (s *Server) addCORSHeaders(w http.ResponseWriter, r *http.Request) {
-	origin := r.Header.Get("Origin")
-
-	// Check if origin is allowed
-	allowed := false
-	for _, allowedOrigin := range s.config.AllowedOrigins {
-		if allowedOrigin == "*" || allowedOrigin == origin {
-			allowed = true
-			break
-		}
+// guardConfig returns the CORS, CSRF and security header settings; with
+// CORS disabled no other origin is allowed
+func (s *Server) guardConfig() *httpguard.Config {
+	config := &httpguard.Config{
+		Policy:         s.config.Security,
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
 	}
-
-	if allowed {
-		w.Header().Set("Access-Control-Allow-Origin", origin)
+	if s.config.EnableCORS {
+		config.AllowedOrigins = s.config.AllowedOrigins
 	}
-
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	return config
 }
 
 // handleHealth handles health check requests
@@ -179,6 +178,7 @@ func (s *Server) handleStatus(w http.ResponseWriter, _ *http.Request) {
 			"active_connections": len(s.hub.GetClients()),
 			"endpoints": []string{
 				"/ws",
+				"/ws/rpc",
 				"/ws/health",
 				"/ws/metrics",
 				"/ws/status",
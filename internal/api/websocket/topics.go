@@ -0,0 +1,130 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Skpow1234/Peervault/internal/pubsub"
+)
+
+// The RPC protocol also publishes to and consumes the durable topics of the
+// node, see fileserver.Server.Topics:
+//
+//	topic.publish   {"topic": "orders/created", "payload": "<base64>"}
+//	topic.subscribe {"topic": "orders/created", "consumer": "billing", "offset": 42}
+//	topic.ack       {"topic": "orders/created", "consumer": "billing", "offset": 42}
+//
+// A subscription starts at offset, or where its consumer group resumes
+// without one, and sends each message as a topic.message notification; it
+// ends with unsubscribe. Groups resume after the last message they
+// acknowledged, so messages are delivered at least once.
+
+// rpcTopicBatch is the most messages read from a log at once
+const rpcTopicBatch = 100
+
+type rpcTopicParams struct {
+	Topic    string `json:"topic"`
+	Payload  []byte `json:"payload,omitempty"`
+	Consumer string `json:"consumer,omitempty"`
+	// Offset is the first message of a subscription and the last one an
+	// acknowledgment covers
+	Offset *uint64 `json:"offset,omitempty"`
+}
+
+// topics returns the durable topics of the node
+func (c *rpcConn) topics() (*pubsub.Topics, error) {
+	if err := c.requireNode(); err != nil {
+		return nil, err
+	}
+	topics := c.handler.node.Topics()
+	if topics == nil {
+		return nil, &RPCError{Code: rpcServerError, Message: "durable topics are not enabled"}
+	}
+	return topics, nil
+}
+
+// topicError reports mistakes of the client as invalid params
+func topicError(err error) error {
+	if errors.Is(err, pubsub.ErrNotDurable) || errors.Is(err, pubsub.ErrNoGroup) {
+		return invalidParams("%s", err.Error())
+	}
+	return err
+}
+
+func (c *rpcConn) publishTopic(id json.RawMessage, p rpcTopicParams) error {
+	topics, err := c.topics()
+	if err != nil {
+		return err
+	}
+	message, err := topics.Publish(c.ctx, p.Topic, p.Payload)
+	if err != nil {
+		return topicError(err)
+	}
+	message.Payload = nil
+	c.reply(id, message, nil)
+	return nil
+}
+
+// subscribeTopic sends the messages of a durable topic as topic.message
+// notifications until the subscription or connection ends
+func (c *rpcConn) subscribeTopic(id json.RawMessage, p rpcTopicParams) error {
+	topics, err := c.topics()
+	if err != nil {
+		return err
+	}
+	offset := pubsub.Resume
+	if p.Offset != nil {
+		offset = int64(*p.Offset)
+	}
+	sub, err := topics.Subscribe(c.ctx, p.Topic, p.Consumer, offset)
+	if err != nil {
+		return topicError(err)
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.mu.Lock()
+	c.nextSubscription++
+	subscription := fmt.Sprintf("sub-%d", c.nextSubscription)
+	c.subscriptions[subscription] = cancel
+	c.mu.Unlock()
+
+	c.reply(id, struct {
+		Subscription string `json:"subscription"`
+		Offset       uint64 `json:"offset"`
+	}{subscription, sub.Offset()}, nil)
+	c.async(func() {
+		for {
+			messages, err := sub.Next(ctx, rpcTopicBatch)
+			if err != nil {
+				if ctx.Err() == nil {
+					c.handler.logger.Error("Failed to read durable topic", "error", err, "topic", p.Topic)
+				}
+				return
+			}
+			for _, m := range messages {
+				c.notify("topic.message", struct {
+					Subscription string         `json:"subscription"`
+					Message      pubsub.Message `json:"message"`
+				}{subscription, m})
+			}
+		}
+	})
+	return nil
+}
+
+func (c *rpcConn) ackTopic(id json.RawMessage, p rpcTopicParams) error {
+	topics, err := c.topics()
+	if err != nil {
+		return err
+	}
+	if p.Offset == nil {
+		return invalidParams("topic.ack needs an offset")
+	}
+	if err := topics.Ack(c.ctx, p.Topic, p.Consumer, *p.Offset); err != nil {
+		return topicError(err)
+	}
+	c.reply(id, map[string]uint64{"offset": *p.Offset}, nil)
+	return nil
+}
@@ -0,0 +1,85 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Skpow1234/Peervault/internal/watch"
+)
+
+// The RPC protocol also watches the keys under a prefix, see
+// fileserver.Server.Watch:
+//
+//	watch {"prefix": "docs/", "token": "<token of the last event received>"}
+//
+// The result holds the subscription and the token the watch starts from.
+// Each change is sent as a watch notification carrying the token that
+// resumes after it; unsubscribe ends the watch. A watch.closed notification
+// reports a watch the node ended because the client fell behind, and the
+// client resumes it with its last token. Watching with a token older than
+// the node remembers fails with the rpcTokenExpired code: the client lists
+// the keys again and watches from the token taken before listing, without
+// one for changes from now on.
+
+type rpcWatchParams struct {
+	Prefix string `json:"prefix,omitempty"`
+	Token  string `json:"token,omitempty"`
+}
+
+// watch sends the changes under a prefix as watch notifications until the
+// subscription or connection ends
+func (c *rpcConn) watch(id json.RawMessage, p rpcWatchParams) error {
+	if err := c.requireNode(); err != nil {
+		return err
+	}
+	node := c.handler.node
+	token := p.Token
+	if token == "" {
+		token = node.WatchToken()
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	ch, err := node.Watch(ctx, p.Prefix, token)
+	if err != nil {
+		cancel()
+		switch {
+		case errors.Is(err, watch.ErrExpired):
+			return &RPCError{Code: rpcTokenExpired, Message: err.Error()}
+		case errors.Is(err, watch.ErrInvalidToken):
+			return invalidParams("%s", err.Error())
+		}
+		return err
+	}
+	c.mu.Lock()
+	c.nextSubscription++
+	subscription := fmt.Sprintf("sub-%d", c.nextSubscription)
+	c.subscriptions[subscription] = cancel
+	c.mu.Unlock()
+
+	c.reply(id, struct {
+		Subscription string `json:"subscription"`
+		Token        string `json:"token"`
+	}{subscription, token}, nil)
+	c.async(func() {
+		for e := range ch {
+			c.notify("watch", struct {
+				Subscription string      `json:"subscription"`
+				Event        watch.Event `json:"event"`
+			}{subscription, e})
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		c.mu.Lock()
+		delete(c.subscriptions, subscription)
+		c.mu.Unlock()
+		cancel()
+		c.notify("watch.closed", map[string]string{
+			"subscription": subscription,
+			"reason":       "the client fell behind; watch again with the token of the last event",
+		})
+	})
+	return nil
+}
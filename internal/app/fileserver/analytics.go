@@ -0,0 +1,44 @@
+package fileserver
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/analytics"
+)
+
+// analyticsFlushInterval is how often access rollups are persisted
+const analyticsFlushInterval = time.Minute
+
+// recordAccess counts an access in the analytics rollups. The tenant is the
+// owner recorded in the metadata store; peer is empty for accesses made
+// through this node.
+func (s *Server) recordAccess(op analytics.Op, key, peer string, size int64) {
+	if s.Analytics == nil {
+		return
+	}
+	var tenant string
+	if s.Metadata != nil {
+		if rec, err := s.Metadata.Get(key); err == nil {
+			tenant = rec.Owner
+		}
+	}
+	s.Analytics.Record(analytics.Access{Op: op, Key: key, Tenant: tenant, Peer: peer, Bytes: size})
+}
+
+// flushAnalytics persists the access rollups every analyticsFlushInterval
+// until the server stops, which flushes them a last time
+func (s *Server) flushAnalytics() {
+	ticker := time.NewTicker(analyticsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Analytics.Flush(); err != nil {
+				slog.Warn("failed to persist access analytics", "error", err)
+			}
+		case <-s.quitch:
+			return
+		}
+	}
+}
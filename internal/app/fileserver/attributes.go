@@ -0,0 +1,56 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/dto"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+)
+
+// ReplicateAttributes sends the tags and metadata recorded for a file to
+// the other nodes owning it, after they changed on this node. Owners that
+// miss the update keep the attributes the file was replicated with.
+func (s *Server) ReplicateAttributes(key string) error {
+	if s.Metadata == nil {
+		return nil
+	}
+	rec, err := s.Metadata.Get(key)
+	if err != nil {
+		return err
+	}
+	hashedKey := crypto.HashKey(key)
+	msg := dto.FileAttributes{Key: key, HashedKey: hashedKey, Tags: rec.Tags, Metadata: rec.Metadata}
+	var errs []error
+	for _, addr := range s.ownerAddrs(hashedKey) {
+		if err := s.send(addr, &Message{Payload: msg}); err != nil {
+			errs = append(errs, fmt.Errorf("peer %s: %w", addr, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// handleMessageFileAttributes records the attributes a peer changed for a
+// file this node holds
+func (s *Server) handleMessageFileAttributes(from string, msg dto.FileAttributes) {
+	if s.Metadata == nil || s.Metadata.Role() != metadata.RolePrimary {
+		return
+	}
+	if crypto.HashKey(msg.Key) != msg.HashedKey || !s.store.Has(s.placement.storageKey(msg.HashedKey)) {
+		return
+	}
+	if err := metadata.ValidateAttributes(msg.Tags, msg.Metadata); err != nil {
+		slog.Warn("refused file attributes", "key", msg.Key, "peer", from, "error", err)
+		return
+	}
+	rec, err := s.Metadata.Get(msg.Key)
+	if err != nil {
+		rec = metadata.FileRecord{Key: msg.Key, HashedKey: msg.HashedKey}
+	}
+	rec.Tags, rec.Metadata = msg.Tags, msg.Metadata
+	if _, err := s.Metadata.Put(rec); err != nil {
+		slog.Warn("failed to record file attributes", "key", msg.Key, "peer", from, "error", err)
+	}
+}
@@ -0,0 +1,204 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/dto"
+	"github.com/Skpow1234/Peervault/internal/hashring"
+	"github.com/Skpow1234/Peervault/internal/storage"
+)
+
+const (
+	// DefaultHighWatermark is the fraction of the capacity in use above
+	// which a node refuses new files when Options.HighWatermark is zero
+	DefaultHighWatermark = 0.9
+	// spaceCheckInterval is how often the free space is measured
+	spaceCheckInterval = 30 * time.Second
+	// spaceAnnounceStep is the change of the free space, as a fraction of
+	// the capacity, that is announced to peers. Smaller changes are not,
+	// as each announcement moves the keys of the node's changed weight.
+	spaceAnnounceStep = 0.05
+)
+
+// ErrStorageFull is returned for new files and replicas refused by a node
+// whose storage is above its high watermark
+var ErrStorageFull = errors.New("storage is above its high watermark")
+
+// StorageSpace is the space of a node for files
+type StorageSpace struct {
+	// Capacity is Options.Capacity when set, the size of the filesystem
+	// holding the storage root otherwise
+	Capacity int64 `json:"capacity"`
+	Used     int64 `json:"used"`
+	// Free is what is left of the capacity, bounded by the free space of
+	// the filesystem
+	Free          int64     `json:"free"`
+	HighWatermark float64   `json:"high_watermark"`
+	Full          bool      `json:"full"`
+	CheckedAt     time.Time `json:"checked_at"`
+}
+
+// full reports whether the space in use reached the watermark
+func (sp StorageSpace) full() bool {
+	return sp.Capacity > 0 && float64(sp.Capacity-sp.Free) >= sp.HighWatermark*float64(sp.Capacity)
+}
+
+// spaceMonitor tracks the free space of this node. Writes count against
+// the last measurement so a burst of them cannot fill the disk between
+// two checks.
+type spaceMonitor struct {
+	mu      sync.Mutex
+	current StorageSpace
+	// announced is the space last advertised to peers, which weighs this
+	// node on every ring
+	announced StorageSpace
+}
+
+// highWatermark returns the configured high watermark
+func (s *Server) highWatermark() float64 {
+	if s.HighWatermark > 0 {
+		return s.HighWatermark
+	}
+	return DefaultHighWatermark
+}
+
+// Space returns the space of this node as last measured, less what was
+// written since
+func (s *Server) Space() StorageSpace {
+	s.space.mu.Lock()
+	defer s.space.mu.Unlock()
+	return s.space.current
+}
+
+// checkSpace refuses new data on a full node: a file not stored yet under
+// key, as updates of held files keep the copies of a file in step
+func (s *Server) checkSpace(key string) error {
+	sp := s.Space()
+	if !sp.Full || s.store.Has(key) {
+		return nil
+	}
+	return fmt.Errorf("%w: %d of %d bytes used", ErrStorageFull, sp.Capacity-sp.Free, sp.Capacity)
+}
+
+// consumeSpace counts n bytes written against the free space. A node
+// filled by the write measures its space again, which tells its peers.
+func (s *Server) consumeSpace(n int64) {
+	m := s.space
+	m.mu.Lock()
+	if m.current.CheckedAt.IsZero() {
+		m.mu.Unlock()
+		return
+	}
+	m.current.Used += n
+	m.current.Free = max(m.current.Free-n, 0)
+	filled := !m.current.Full && m.current.full()
+	m.mu.Unlock()
+	if filled {
+		go s.refreshSpace()
+	}
+}
+
+// measureSpace measures the space of the node
+func (s *Server) measureSpace() (StorageSpace, error) {
+	sp := StorageSpace{HighWatermark: s.highWatermark(), CheckedAt: time.Now()}
+	disk, diskErr := s.store.DiskSpace()
+	if s.Capacity > 0 {
+		used, err := s.store.Usage()
+		if err != nil {
+			return StorageSpace{}, err
+		}
+		sp.Capacity, sp.Used = s.Capacity, used
+		sp.Free = max(s.Capacity-used, 0)
+		if diskErr == nil {
+			sp.Free = min(sp.Free, disk.Free)
+		}
+	} else {
+		if diskErr != nil {
+			return StorageSpace{}, diskErr
+		}
+		sp.Capacity, sp.Free = disk.Total, disk.Free
+		sp.Used = disk.Total - disk.Free
+	}
+	sp.Full = sp.full()
+	return sp, nil
+}
+
+// refreshSpace measures the space, and announces it to the peers when it
+// changed by more than spaceAnnounceStep or the node became full or had
+// room again
+func (s *Server) refreshSpace() {
+	sp, err := s.measureSpace()
+	if err != nil {
+		if !errors.Is(err, storage.ErrSpaceUnsupported) {
+			slog.Warn("failed to measure storage space", "error", err)
+		}
+		return
+	}
+
+	m := s.space
+	m.mu.Lock()
+	was := m.current
+	m.current = sp
+	prev := m.announced
+	announce := prev.CheckedAt.IsZero() || sp.Full != prev.Full ||
+		float64(abs(sp.Free-prev.Free)) >= spaceAnnounceStep*float64(sp.Capacity)
+	if announce {
+		m.announced = sp
+	}
+	m.mu.Unlock()
+
+	switch {
+	case sp.Full && !was.Full:
+		slog.Warn("storage reached its high watermark, refusing new files", "free", sp.Free, "capacity", sp.Capacity)
+	case !sp.Full && was.Full:
+		slog.Info("storage is below its high watermark again", "free", sp.Free, "capacity", sp.Capacity)
+	}
+	if !announce {
+		return
+	}
+
+	p := s.placement
+	p.mu.Lock()
+	ring := p.ring.With(hashring.Node{ID: s.ID, Weight: nodeWeight(s.nodeInfo())})
+	changed := ring != p.ring
+	p.ring = ring
+	p.mu.Unlock()
+	s.broadcastNodeInfo()
+	if changed && ring.Len() > 1 {
+		s.scheduleRebalance()
+	}
+}
+
+// monitorSpace refreshes the space until the server stops
+func (s *Server) monitorSpace() {
+	ticker := time.NewTicker(spaceCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.refreshSpace()
+		case <-s.quitch:
+			return
+		}
+	}
+}
+
+// nodeWeight is the ring weight of a node: its free space when it
+// advertises it, its capacity otherwise
+func nodeWeight(info dto.NodeInfo) float64 {
+	if info.Free > 0 {
+		return capacityWeight(info.Free)
+	}
+	return capacityWeight(info.Capacity)
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
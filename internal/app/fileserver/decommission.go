@@ -0,0 +1,320 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/hashring"
+)
+
+// decommissionRetryInterval is how long to wait before moving the files
+// that could not be moved again
+const decommissionRetryInterval = 2 * time.Second
+
+var (
+	// ErrDecommissioning is returned for starting a decommission on a node
+	// that is already leaving or has left the cluster
+	ErrDecommissioning = errors.New("the node is already being decommissioned")
+	// ErrNotDecommissioning is returned for aborting a decommission that is
+	// not running
+	ErrNotDecommissioning = errors.New("the node is not being decommissioned")
+	// ErrNoRemainingNodes is returned for decommissioning the only node on
+	// the ring, which has nowhere to move its files
+	ErrNoRemainingNodes = errors.New("no other node on the ring to move files to")
+	// errDecommissioned refuses peers connecting to a decommissioned node
+	errDecommissioned = errors.New("the node is decommissioned")
+)
+
+// DecommissionPhase is where a node is in leaving the cluster
+type DecommissionPhase string
+
+const (
+	// DecommissionActive nodes are members of the cluster
+	DecommissionActive DecommissionPhase = "active"
+	// DecommissionEvacuating nodes are off the ring and copying their files
+	// to the remaining nodes
+	DecommissionEvacuating DecommissionPhase = "evacuating"
+	// DecommissionCompleted nodes moved their files and left the cluster;
+	// they can be stopped
+	DecommissionCompleted DecommissionPhase = "decommissioned"
+	// DecommissionAborted nodes stopped leaving and are back on the ring
+	DecommissionAborted DecommissionPhase = "aborted"
+)
+
+// DecommissionStatus reports the progress of a node leaving the cluster
+type DecommissionStatus struct {
+	Phase DecommissionPhase `json:"phase"`
+	// Files is the number of local files found so far, including those
+	// written while evacuating
+	Files int `json:"files"`
+	// Evacuated counts the files every remaining owner holds a copy of
+	Evacuated int `json:"evacuated"`
+	// Pending counts the files whose last attempt failed; they are retried
+	// until the decommission is aborted
+	Pending int `json:"pending"`
+	// Copies counts the copies pushed to the remaining nodes
+	Copies int `json:"copies"`
+	// Bytes is the stored size of the copies pushed
+	Bytes      int64     `json:"bytes"`
+	LastError  string    `json:"last_error,omitempty"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// A decommission takes the node off the ring of every peer, so it owns no
+// files, then copies each local file to the owners it has among the
+// remaining nodes that lack it. Local copies are kept: the node leaves with
+// its data intact. Once every file is placed the node disconnects from its
+// peers and refuses new ones. The state lives in memory; a restarted node
+// rejoins the cluster.
+type decommission struct {
+	mu     sync.Mutex
+	status DecommissionStatus
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newDecommission() *decommission {
+	return &decommission{status: DecommissionStatus{Phase: DecommissionActive}}
+}
+
+// phase returns the current phase
+func (d *decommission) phase() DecommissionPhase {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status.Phase
+}
+
+// update applies f to the status
+func (d *decommission) update(f func(*DecommissionStatus)) {
+	d.mu.Lock()
+	f(&d.status)
+	d.status.UpdatedAt = time.Now()
+	d.mu.Unlock()
+}
+
+// stop cancels a running evacuation and waits for it to return
+func (d *decommission) stop() {
+	d.mu.Lock()
+	cancel, done := d.cancel, d.done
+	d.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// leaving reports whether this node is leaving or has left the cluster
+func (s *Server) leaving() bool {
+	switch s.decommission.phase() {
+	case DecommissionEvacuating, DecommissionCompleted:
+		return true
+	}
+	return false
+}
+
+// Decommission starts moving this node's files to the rest of the cluster
+// so the node can leave it. It returns at once; DecommissionStatus reports
+// the progress.
+func (s *Server) Decommission() (DecommissionStatus, error) {
+	d := s.decommission
+	d.mu.Lock()
+	if d.status.Phase == DecommissionEvacuating || d.status.Phase == DecommissionCompleted {
+		defer d.mu.Unlock()
+		return d.status, ErrDecommissioning
+	}
+	if s.Ring().Without(s.ID).Len() == 0 {
+		defer d.mu.Unlock()
+		return d.status, ErrNoRemainingNodes
+	}
+
+	now := time.Now()
+	d.status = DecommissionStatus{Phase: DecommissionEvacuating, StartedAt: now, UpdatedAt: now}
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel, d.done = cancel, make(chan struct{})
+	st := d.status
+	d.mu.Unlock()
+
+	go func() {
+		select {
+		case <-s.quitch:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	go s.evacuate(ctx, d.done)
+
+	slog.Info("decommissioning node", "node", s.ID)
+	s.broadcastNodeInfo()
+	return st, nil
+}
+
+// AbortDecommission stops moving files and puts this node back on the
+// ring. The copies already made stay where they are; rebalancing drops
+// those the remaining nodes no longer own.
+func (s *Server) AbortDecommission() (DecommissionStatus, error) {
+	d := s.decommission
+	d.mu.Lock()
+	if d.status.Phase != DecommissionEvacuating {
+		defer d.mu.Unlock()
+		return d.status, ErrNotDecommissioning
+	}
+	d.status.Phase = DecommissionAborted
+	d.mu.Unlock()
+
+	d.stop()
+	d.update(func(st *DecommissionStatus) { st.FinishedAt = st.UpdatedAt })
+	slog.Info("aborted decommissioning", "node", s.ID)
+	s.broadcastNodeInfo()
+	return s.DecommissionStatus(), nil
+}
+
+// DecommissionStatus returns the progress of the decommission
+func (s *Server) DecommissionStatus() DecommissionStatus {
+	d := s.decommission
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status
+}
+
+// broadcastNodeInfo tells every peer how this node introduces itself
+func (s *Server) broadcastNodeInfo() {
+	info := s.nodeInfo()
+	for _, addr := range s.peerAddrs() {
+		if err := s.send(addr, &Message{Payload: info}); err != nil {
+			slog.Warn("failed to announce node", "peer", addr, "error", err)
+		}
+	}
+}
+
+// evacuate moves the local files until every one is placed on the
+// remaining nodes, then leaves the cluster
+func (s *Server) evacuate(ctx context.Context, done chan struct{}) {
+	d := s.decommission
+	defer func() {
+		d.mu.Lock()
+		d.cancel()
+		d.cancel = nil
+		d.mu.Unlock()
+		close(done)
+	}()
+	evacuated := make(map[string]bool)
+	for {
+		p := s.placement
+		p.mu.Lock()
+		ring := p.ring.Without(s.ID)
+		addrs := maps.Clone(p.addrs)
+		held := maps.Clone(p.held)
+		p.mu.Unlock()
+
+		// Files written while evacuating are picked up by the next pass
+		keys := slices.Sorted(maps.Keys(held))
+		keys = slices.DeleteFunc(keys, func(k string) bool { return evacuated[k] })
+		d.update(func(st *DecommissionStatus) { st.Files = len(evacuated) + len(keys) })
+		if len(keys) == 0 {
+			break
+		}
+
+		pending := 0
+		for _, hashedKey := range keys {
+			copies, bytes, err := s.evacuateFile(ctx, ring, addrs, hashedKey, held[hashedKey])
+			if ctx.Err() != nil {
+				return
+			}
+			d.update(func(st *DecommissionStatus) {
+				st.Copies += copies
+				st.Bytes += bytes
+				if err != nil {
+					st.LastError = fmt.Sprintf("%s: %v", hashedKey, err)
+				} else {
+					st.Evacuated++
+				}
+			})
+			if err != nil {
+				slog.Warn("failed to move file off the node", "key", hashedKey, "error", err)
+				pending++
+				continue
+			}
+			evacuated[hashedKey] = true
+		}
+		d.update(func(st *DecommissionStatus) { st.Pending = pending })
+		if pending == 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(decommissionRetryInterval):
+		}
+	}
+
+	d.mu.Lock()
+	if d.status.Phase != DecommissionEvacuating {
+		// Aborted
+		d.mu.Unlock()
+		return
+	}
+	d.status.Phase = DecommissionCompleted
+	d.status.FinishedAt = time.Now()
+	d.status.UpdatedAt = d.status.FinishedAt
+	st := d.status
+	d.mu.Unlock()
+
+	slog.Info("node decommissioned", "node", s.ID, "files", st.Files, "copies", st.Copies)
+	for _, addr := range s.peerAddrs() {
+		s.Disconnect(addr)
+	}
+}
+
+// evacuateFile copies a local file to its owners among the remaining
+// nodes that do not hold it, returning the copies pushed and their size
+func (s *Server) evacuateFile(ctx context.Context, ring *hashring.Ring, addrs map[string]string, hashedKey string, c localCopy) (int, int64, error) {
+	var targets []string
+	for _, id := range ring.Owners(hashedKey, s.replicationFactor()) {
+		if addr, ok := addrs[id]; ok {
+			targets = append(targets, addr)
+		}
+	}
+	if len(targets) == 0 {
+		return 0, 0, ErrNoRemainingNodes
+	}
+
+	// Owners that do not answer in time are copied to anyway
+	answers, _, err := s.queryReplicas(ctx, hashedKey, targets)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, a := range answers {
+		if a.ack.HasFile {
+			targets = slices.DeleteFunc(targets, func(addr string) bool { return addr == a.addr })
+		}
+	}
+	if len(targets) == 0 {
+		return 0, 0, nil
+	}
+
+	size, r, err := s.store.Read(c.storageKey)
+	if err != nil {
+		return 0, 0, err
+	}
+	_ = r.Close()
+
+	copies := 0
+	var errs []error
+	for _, addr := range targets {
+		if err := s.push(ctx, addr, hashedKey, c.storageKey, nil, nil); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+			continue
+		}
+		copies++
+	}
+	return copies, int64(copies) * size, errors.Join(errs...)
+}
@@ -0,0 +1,112 @@
+package fileserver
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DefaultSeedInterval is how often seed sources are listed
+const DefaultSeedInterval = time.Minute
+
+// seedTimeout bounds the listing of the seed sources
+const seedTimeout = 10 * time.Second
+
+// discoveryRetry is how long a discovered peer is left alone after a dial,
+// so a peer the handshake or the ACL rejects is not dialed every time it
+// announces itself
+const discoveryRetry = time.Minute
+
+// discoveredPeers are the last dials of peers found by discovery or seed
+// sources, by node ID or, for seeds without one, by address
+type discoveredPeers struct {
+	mu     sync.Mutex
+	dialed map[string]time.Time
+}
+
+func newDiscoveredPeers() *discoveredPeers {
+	return &discoveredPeers{dialed: make(map[string]time.Time)}
+}
+
+// ConnectDiscovered dials a peer found by local discovery, such as mDNS,
+// unless it is connected or was dialed recently, and reports whether it
+// dials. Of two nodes discovering each other only the one with the lower
+// ID dials, so they do not connect twice. The announced ID is not
+// trusted: the dial goes through the handshake and the peer ACL like any
+// other.
+func (s *Server) ConnectDiscovered(id, addr string) bool {
+	if id == s.ID || id < s.ID || s.Ring().Has(id) {
+		return false
+	}
+	return s.dialDiscovered("id:"+id, addr)
+}
+
+// dialDiscovered dials addr unless the peer known as key was dialed
+// recently
+func (s *Server) dialDiscovered(key, addr string) bool {
+	d := s.discovered
+	d.mu.Lock()
+	now := time.Now()
+	if last, ok := d.dialed[key]; ok && now.Sub(last) < discoveryRetry {
+		d.mu.Unlock()
+		return false
+	}
+	d.dialed[key] = now
+	for other, last := range d.dialed {
+		if now.Sub(last) >= discoveryRetry {
+			delete(d.dialed, other)
+		}
+	}
+	d.mu.Unlock()
+
+	go func() {
+		slog.Info("connecting to discovered peer", "peer", key, "addr", addr)
+		if err := s.Transport.Dial(addr); err != nil {
+			slog.Warn("failed to connect to discovered peer", "peer", key, "addr", addr, "err", err)
+		}
+	}()
+	return true
+}
+
+// seed connects to the peers of the seed sources until the server stops
+func (s *Server) seed() {
+	interval := s.SeedInterval
+	if interval <= 0 {
+		interval = DefaultSeedInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.connectSeeds()
+		select {
+		case <-ticker.C:
+		case <-s.quitch:
+			return
+		}
+	}
+}
+
+// connectSeeds lists the seed sources and dials their peers. Peers with a
+// known ID are dialed like discovered ones. Addresses alone cannot be
+// told apart from connected peers, so they are only dialed while this
+// node has no peers, to join the cluster.
+func (s *Server) connectSeeds() {
+	ctx, cancel := context.WithTimeout(context.Background(), seedTimeout)
+	defer cancel()
+	for _, source := range s.Seeds {
+		peers, err := source.Peers(ctx)
+		if err != nil {
+			slog.Warn("failed to list seed peers", "err", err)
+			continue
+		}
+		for _, p := range peers {
+			switch {
+			case p.ID != "":
+				s.ConnectDiscovered(p.ID, p.Addr)
+			case s.Ring().Len() == 1 && p.Addr != s.Transport.Addr():
+				s.dialDiscovered("addr:"+p.Addr, p.Addr)
+			}
+		}
+	}
+}
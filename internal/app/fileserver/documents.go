@@ -0,0 +1,102 @@
+package fileserver
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/crdt"
+	"github.com/Skpow1234/Peervault/internal/dto"
+	"github.com/Skpow1234/Peervault/internal/events"
+)
+
+// DefaultDocumentGossipInterval is how often a node compares its shared
+// documents with a random peer
+const DefaultDocumentGossipInterval = 10 * time.Second
+
+// Shared documents are CRDTs every node keeps a copy of. Updates are pushed
+// to every peer right away; in between, each node periodically swaps
+// digests with a random peer, and both send the states the other lacks, so
+// peers that missed an update, or joined later, catch up.
+
+// Documents returns the names of the shared documents
+func (s *Server) Documents() []string { return s.documents.Names() }
+
+// Document returns what a shared document holds
+func (s *Server) Document(name string) (crdt.View, error) { return s.documents.Get(name) }
+
+// UpdateDocument applies an update to a shared document, creating it when
+// it does not exist, and sends the document to every peer
+func (s *Server) UpdateDocument(name string, u crdt.Update) (crdt.View, error) {
+	view, state, err := s.documents.Apply(name, u)
+	if err != nil {
+		return crdt.View{}, err
+	}
+	s.Events.Publish(events.Event{Type: events.DocumentUpdated, Key: name, Data: view})
+	for _, addr := range s.peerAddrs() {
+		if err := s.send(addr, &Message{Payload: dto.DocumentState{Name: name, State: state}}); err != nil {
+			slog.Warn("failed to send document", "document", name, "peer", addr, "error", err)
+		}
+	}
+	return view, nil
+}
+
+// gossipDocuments swaps document digests with a random peer every
+// interval until the server stops
+func (s *Server) gossipDocuments() {
+	interval := s.DocumentGossipInterval
+	if interval <= 0 {
+		interval = DefaultDocumentGossipInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			peers := s.peerAddrs()
+			if len(peers) == 0 {
+				continue
+			}
+			addr := peers[rand.IntN(len(peers))]
+			if err := s.send(addr, &Message{Payload: dto.DocumentDigest{Digests: s.documents.Digests()}}); err != nil {
+				slog.Warn("failed to gossip documents", "peer", addr, "error", err)
+			}
+		case <-s.quitch:
+			return
+		}
+	}
+}
+
+// handleMessageDocumentDigest sends a peer the documents whose digests
+// differ from its own, then its digest when the peer started the exchange
+func (s *Server) handleMessageDocumentDigest(from string, msg dto.DocumentDigest) error {
+	for name, digest := range s.documents.Digests() {
+		if msg.Digests[name] == digest {
+			continue
+		}
+		if state, ok := s.documents.State(name); ok {
+			if err := s.send(from, &Message{Payload: dto.DocumentState{Name: name, State: state}}); err != nil {
+				return err
+			}
+		}
+	}
+	if msg.Reply {
+		return nil
+	}
+	return s.send(from, &Message{Payload: dto.DocumentDigest{Digests: s.documents.Digests(), Reply: true}})
+}
+
+// handleMessageDocumentState merges a document received from a peer
+func (s *Server) handleMessageDocumentState(from string, msg dto.DocumentState) {
+	changed, err := s.documents.Merge(msg.Name, msg.State)
+	if err != nil {
+		slog.Warn("failed to merge document", "document", msg.Name, "peer", from, "error", err)
+		return
+	}
+	if !changed {
+		return
+	}
+	if view, err := s.documents.Get(msg.Name); err == nil {
+		s.Events.Publish(events.Event{Type: events.DocumentUpdated, Key: msg.Name, Data: view})
+	}
+}
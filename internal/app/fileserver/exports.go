@@ -0,0 +1,31 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/Skpow1234/Peervault/internal/export"
+)
+
+// ErrExportsDisabled is returned when the server has no exports
+var ErrExportsDisabled = errors.New("fileserver: exports are not enabled")
+
+// RunExport runs an export now, storing its file in the cluster unless
+// the export writes to a target of its own
+func (s *Server) RunExport(ctx context.Context, name string) (*export.Run, error) {
+	if s.Exports == nil {
+		return nil, ErrExportsDisabled
+	}
+	src := export.Source{Analytics: s.Analytics}
+	if s.Metadata != nil {
+		src.Records = s.Metadata.List
+	}
+	run, err := s.Exports.Run(ctx, name, src, s.storeBytes)
+	if err != nil {
+		slog.Warn("export failed", "export", name, "error", err)
+		return nil, err
+	}
+	slog.Info("export written", "export", name, "destination", run.Destination, "key", run.Key, "rows", run.Rows)
+	return run, nil
+}
@@ -0,0 +1,321 @@
+package fileserver
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/Skpow1234/Peervault/internal/storage"
+)
+
+// Problems fsck finds beyond the broken invariants of the store
+const (
+	// FsckMissing files are in the version index but not in the store
+	FsckMissing = "missing"
+	// invariantIndex and invariantEncryption name what the findings of the
+	// version index and of copies failing to decrypt break
+	invariantIndex      = "index"
+	invariantEncryption = "encryption"
+)
+
+// Repairs fsck makes
+const (
+	// RepairCollected findings were removed by a garbage collection
+	RepairCollected = "collected"
+	// RepairQuarantined findings were moved out of the store, with no copy
+	// to replace them
+	RepairQuarantined = "quarantined"
+	// RepairRefetched findings were moved out of the store and replaced by
+	// a copy fetched from a peer
+	RepairRefetched = "refetched"
+	// RepairDropped findings were versions conflicting with the copy of a
+	// file, forgotten as no copy of them is left
+	RepairDropped = "dropped"
+)
+
+// FsckFinding is a problem found in the local store or its version index
+type FsckFinding struct {
+	Problem   string `json:"problem"`
+	Invariant string `json:"invariant"`
+	// Location is the file or chunked object directory of the problem,
+	// relative to the store root
+	Location   string `json:"location,omitempty"`
+	Path       string `json:"path,omitempty"`
+	HashedKey  string `json:"hashed_key,omitempty"`
+	StorageKey string `json:"storage_key,omitempty"`
+	Detail     string `json:"detail"`
+	// Repair is what was done about the finding; empty when nothing was
+	Repair      string `json:"repair,omitempty"`
+	RepairError string `json:"repair_error,omitempty"`
+}
+
+// FsckReport is the result of checking the local store
+type FsckReport struct {
+	Root    string `json:"root"`
+	Format  string `json:"format"`
+	Objects int    `json:"objects"`
+	Chunks  int    `json:"chunks"`
+	// Indexed counts the copies in the version index; Unindexed the
+	// objects of the store it does not list, stored before versions were
+	// tracked or by a node not persisting them
+	Indexed   int           `json:"indexed"`
+	Unindexed int           `json:"unindexed"`
+	Findings  []FsckFinding `json:"findings"`
+	// Unrepaired counts the findings left without a repair
+	Unrepaired int `json:"unrepaired"`
+}
+
+// indexedCopy is a copy listed in the version index
+type indexedCopy struct {
+	hashedKey  string
+	storageKey string
+	current    bool
+}
+
+// Fsck checks the local store for orphaned data, truncated and corrupt
+// objects, manifests referencing missing chunks and objects out of place,
+// and checks every copy in the version index is stored and decrypts. With
+// repair, orphaned data is collected and broken objects are quarantined,
+// then refetched from peers when they are the current copy of a file; the
+// peers must be connected for refetches to succeed.
+func (s *Server) Fsck(ctx context.Context, repair bool) (*FsckReport, error) {
+	if err := s.versions.load(); err != nil {
+		return nil, err
+	}
+	check, err := s.store.Check()
+	if err != nil {
+		return nil, err
+	}
+	report := &FsckReport{Root: s.store.Root, Format: check.Format, Objects: check.Objects, Chunks: check.Chunks, Findings: []FsckFinding{}}
+
+	copies := s.indexedCopies()
+	byLocation := make(map[string]indexedCopy)
+	for _, c := range copies {
+		for _, location := range s.store.Locations(c.storageKey) {
+			byLocation[location] = c
+		}
+	}
+	broken := make(map[string]bool)
+	for _, v := range check.Violations {
+		f := FsckFinding{Problem: v.Problem, Invariant: v.Invariant, Location: v.Location, Path: v.Path, Detail: v.Detail}
+		if c, ok := byLocation[v.Location]; ok {
+			f.HashedKey, f.StorageKey = c.hashedKey, c.storageKey
+		}
+		report.Findings = append(report.Findings, f)
+		broken[v.Location] = true
+	}
+
+	indexed := make(map[string]bool)
+	var decrypted int
+	var undecryptable []FsckFinding
+	for _, c := range copies {
+		report.Indexed++
+		indexed[s.store.PathTransformFunc(c.storageKey).FullPath()] = true
+		locations := s.storedLocations(c.storageKey)
+		if len(locations) == 0 {
+			report.Findings = append(report.Findings, FsckFinding{
+				Problem:    FsckMissing,
+				Invariant:  invariantIndex,
+				HashedKey:  c.hashedKey,
+				StorageKey: c.storageKey,
+				Detail:     "indexed copy is not stored",
+			})
+			continue
+		}
+		if slices.ContainsFunc(locations, func(l string) bool { return broken[l] }) {
+			continue
+		}
+		if _, err := s.decryptStored(c.storageKey); err == nil {
+			decrypted++
+		} else {
+			undecryptable = append(undecryptable, FsckFinding{
+				Problem:    storage.ProblemCorrupt,
+				Invariant:  invariantEncryption,
+				Location:   locations[0],
+				HashedKey:  c.hashedKey,
+				StorageKey: c.storageKey,
+				Detail:     err.Error(),
+			})
+		}
+	}
+	// A node given the wrong key decrypts nothing, which is no reason to
+	// quarantine its store. With a single copy there is no telling.
+	if decrypted == 0 && len(undecryptable) > 1 {
+		return nil, fmt.Errorf("none of the %d indexed copies decrypts with the node's key", len(undecryptable))
+	}
+	report.Findings = append(report.Findings, undecryptable...)
+	if objects, err := s.store.Objects(); err == nil {
+		for _, fullPath := range objects {
+			if !indexed[fullPath] {
+				report.Unindexed++
+			}
+		}
+	}
+
+	if repair {
+		s.repair(ctx, report, copies)
+	}
+	for _, f := range report.Findings {
+		if f.Repair == "" {
+			report.Unrepaired++
+		}
+	}
+	return report, nil
+}
+
+// indexedCopies lists the copies in the version index
+func (s *Server) indexedCopies() []indexedCopy {
+	t := s.versions
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var copies []indexedCopy
+	for hashedKey, kv := range t.keys {
+		copies = append(copies, indexedCopy{hashedKey: hashedKey, storageKey: kv.Current.StorageKey, current: true})
+		for _, sib := range kv.Siblings {
+			copies = append(copies, indexedCopy{hashedKey: hashedKey, storageKey: sib.StorageKey})
+		}
+	}
+	slices.SortFunc(copies, func(a, b indexedCopy) int { return cmp.Compare(a.storageKey, b.storageKey) })
+	return copies
+}
+
+// storedLocations returns the locations a copy is stored at
+func (s *Server) storedLocations(storageKey string) []string {
+	var locations []string
+	for _, location := range s.store.Locations(storageKey) {
+		if _, err := os.Lstat(filepath.Join(s.store.Root, filepath.FromSlash(location))); err == nil {
+			locations = append(locations, location)
+		}
+	}
+	return locations
+}
+
+// repair repairs the findings of a report, recording what was done on each
+func (s *Server) repair(ctx context.Context, report *FsckReport, copies []indexedCopy) {
+	// A location with anything but orphaned data is quarantined whole,
+	// orphaned data elsewhere is collected
+	quarantine := make(map[string]bool)
+	orphans := false
+	for _, f := range report.Findings {
+		switch f.Problem {
+		case FsckMissing:
+		case storage.ProblemOrphan:
+			orphans = true
+		default:
+			quarantine[f.Location] = true
+		}
+	}
+	quarantined := make(map[string]error)
+	for _, location := range slices.Sorted(maps.Keys(quarantine)) {
+		dst, err := s.store.Quarantine(location)
+		if err == nil {
+			slog.Warn("quarantined broken object", "location", location, "to", dst)
+		}
+		quarantined[location] = err
+	}
+	var gcErr error
+	if orphans {
+		_, gcErr = s.store.GC()
+	}
+
+	byKey := make(map[string]indexedCopy, len(copies))
+	for _, c := range copies {
+		byKey[c.storageKey] = c
+	}
+	type replacement struct {
+		repair string
+		err    error
+	}
+	replaced := make(map[string]replacement)
+	for i := range report.Findings {
+		f := &report.Findings[i]
+		if f.Problem == storage.ProblemOrphan && !quarantine[f.Location] {
+			setRepair(f, RepairCollected, gcErr)
+			continue
+		}
+		if f.Problem != FsckMissing {
+			if err := quarantined[f.Location]; err != nil {
+				setRepair(f, "", err)
+				continue
+			}
+			f.Repair = RepairQuarantined
+		}
+		c, ok := byKey[f.StorageKey]
+		if !ok {
+			continue
+		}
+		r, done := replaced[c.storageKey]
+		if !done {
+			r.repair, r.err = s.replaceCopy(ctx, c)
+			replaced[c.storageKey] = r
+		}
+		setRepair(f, r.repair, r.err)
+	}
+}
+
+// replaceCopy replaces a copy of the index that is lost: the current copy
+// of a file is fetched from the peers holding it, a conflicting version is
+// forgotten
+func (s *Server) replaceCopy(ctx context.Context, c indexedCopy) (string, error) {
+	for _, location := range s.storedLocations(c.storageKey) {
+		if _, err := s.store.Quarantine(location); err != nil {
+			return "", err
+		}
+	}
+	if !c.current {
+		s.dropSibling(c.hashedKey, c.storageKey)
+		return RepairDropped, nil
+	}
+
+	var errs []error
+	for _, addr := range s.fetchOrder(c.hashedKey) {
+		data, err := s.fetch(ctx, addr, c.hashedKey)
+		if err == nil {
+			if _, err := s.writeEncrypted(c.storageKey, bytes.NewReader(data)); err != nil {
+				return "", err
+			}
+			slog.Info("refetched broken file", "key", c.storageKey, "peer", addr)
+			return RepairRefetched, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+	}
+	if len(errs) == 0 {
+		return "", errors.New("no peer to fetch the file from")
+	}
+	return "", fmt.Errorf("no peer sent the file: %w", errors.Join(errs...))
+}
+
+// dropSibling forgets a version conflicting with the copy of a file
+func (s *Server) dropSibling(hashedKey, storageKey string) {
+	t := s.versions
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	kv, ok := t.keys[hashedKey]
+	if !ok {
+		return
+	}
+	kv.Siblings = slices.DeleteFunc(kv.Siblings, func(sib storedVersion) bool { return sib.StorageKey == storageKey })
+	if err := t.saveLocked(); err != nil {
+		slog.Warn("failed to save file versions", "error", err)
+	}
+}
+
+// setRepair records a repair, or why it failed; a failed repair keeps what
+// was done before it
+func setRepair(f *FsckFinding, repair string, err error) {
+	if err != nil {
+		f.RepairError = err.Error()
+		return
+	}
+	f.Repair = repair
+}
@@ -0,0 +1,39 @@
+package fileserver
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/geo"
+)
+
+// Nodes returns this node and the peers on the ring, with where they are
+// and how fast they answer this node, for routing clients to the nearest.
+// Peers leave the list when they disconnect or start leaving the cluster.
+func (s *Server) Nodes() []geo.Node {
+	self := geo.Node{ID: s.ID, Region: s.Region, URL: s.PublicURL, Self: true}
+	if s.Location != nil {
+		self.Point, self.HasPoint = *s.Location, true
+	}
+	nodes := []geo.Node{self}
+
+	p := s.placement
+	p.mu.Lock()
+	for id, info := range p.nodes {
+		n := geo.Node{
+			ID:       id,
+			Region:   info.Region,
+			Point:    geo.Point{Lat: info.Latitude, Lon: info.Longitude},
+			HasPoint: info.Located,
+			URL:      info.URL,
+		}
+		if addr, ok := p.addrs[id]; ok {
+			n.RTT, _ = s.latency.RTT(addr)
+		}
+		nodes = append(nodes, n)
+	}
+	p.mu.Unlock()
+
+	slices.SortFunc(nodes[1:], func(a, b geo.Node) int { return strings.Compare(a.ID, b.ID) })
+	return nodes
+}
@@ -0,0 +1,40 @@
+package fileserver
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/events"
+	"github.com/Skpow1234/Peervault/internal/hooks"
+)
+
+// hookEventPrefix is put in front of the types of events hooks emit
+const hookEventPrefix = "hook."
+
+// runHooks runs the hooks of point on in and publishes the events they
+// emit. Without hooks the metadata of in is returned as it is.
+func (s *Server) runHooks(ctx context.Context, point hooks.Point, in hooks.Input) (map[string]string, error) {
+	if s.Hooks == nil || !s.Hooks.Has(point) {
+		return in.Metadata, nil
+	}
+	res, err := s.Hooks.Run(ctx, point, in)
+	for _, e := range res.Events {
+		s.Events.Publish(events.Event{Type: hookEventPrefix + e.Type, Key: e.Key, Data: e})
+	}
+	return res.Metadata, err
+}
+
+// runFileHooks runs the hooks of point on a stored file, which they see
+// with the size, tags and metadata of its record
+func (s *Server) runFileHooks(ctx context.Context, point hooks.Point, key string) error {
+	if s.Hooks == nil || !s.Hooks.Has(point) {
+		return nil
+	}
+	in := hooks.Input{Key: key}
+	if s.Metadata != nil {
+		if rec, err := s.Metadata.Get(key); err == nil {
+			in.Size, in.Tags, in.Metadata = rec.Size, rec.Tags, rec.Metadata
+		}
+	}
+	_, err := s.runHooks(ctx, point, in)
+	return err
+}
@@ -0,0 +1,71 @@
+package fileserver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/scheduler"
+)
+
+// registerJobs puts the periodic work of the server on its scheduler
+func (s *Server) registerJobs() error {
+	var jobs []scheduler.Job
+	if s.Reports != nil {
+		jobs = append(jobs, scheduler.Job{
+			Name:        "reports",
+			Description: "Run the analytics reports that are due",
+			Schedule:    "* * * * *",
+			Run:         s.runDueReports,
+		})
+	}
+	if s.Exports != nil {
+		for _, e := range s.Exports.Exports() {
+			if e.Schedule == "" {
+				continue
+			}
+			name := e.Name
+			jobs = append(jobs, scheduler.Job{
+				Name:        "export-" + name,
+				Description: fmt.Sprintf("Export %s as %s", e.Dataset, e.Format),
+				Schedule:    e.Schedule,
+				Run: func(ctx context.Context) error {
+					_, err := s.RunExport(ctx, name)
+					return err
+				},
+			})
+		}
+	}
+	if s.rotation != nil && s.rotation.interval > 0 {
+		jobs = append(jobs, scheduler.Job{
+			Name:        "key-rotation",
+			Description: "Rotate the encryption key and re-encrypt the stored objects",
+			Schedule:    every(s.rotation.interval),
+			Last:        s.rotation.rotatedAt,
+			Run: func(ctx context.Context) error {
+				_, err := s.rotation.rotate()
+				return err
+			},
+		})
+	}
+	if s.Edge != nil {
+		jobs = append(jobs, scheduler.Job{
+			Name:        "edge-gc",
+			Description: "Delete the pulled files no one asked for since they went stale",
+			Schedule:    every(s.Edge.TTL()),
+			Run:         s.expirePulled,
+		})
+	}
+	for _, job := range jobs {
+		if err := s.Scheduler.Register(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// every returns the schedule running at an interval, of at least the
+// minute schedules resolve to
+func every(interval time.Duration) string {
+	return "@every " + max(interval, time.Minute).String()
+}
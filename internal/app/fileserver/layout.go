@@ -0,0 +1,35 @@
+package fileserver
+
+import (
+	"log/slog"
+
+	"github.com/Skpow1234/Peervault/internal/upgrade"
+)
+
+// layoutSteps upgrade the on-disk layout of a node, step i to version
+// i+1. Steps are only ever appended: a released step is never changed or
+// reordered, as nodes record the version they are at.
+func (s *Server) layoutSteps() []upgrade.Step {
+	return []upgrade.Step{
+		{Description: "record the format of stores predating the format file", Apply: s.store.RecordFormat},
+		{Description: "record the format of the version index", Apply: s.versions.upgradeFormat},
+	}
+}
+
+// UpgradeLayout upgrades the on-disk layout of the node, its store and
+// version index, to the one this release writes. The version index and the
+// files recording the store's format are backed up under the store root
+// first. With dryRun, it reports the steps that would run and changes
+// nothing. Start runs it; it fails with upgrade.ErrTooNew for a layout
+// written by a newer release.
+func (s *Server) UpgradeLayout(dryRun bool) (*upgrade.Report, error) {
+	files := s.store.StateFiles()
+	if s.versions.path != "" {
+		files = append(files, s.versions.path)
+	}
+	report, err := upgrade.Run(upgrade.Options{Dir: s.store.Root, Steps: s.layoutSteps(), Backup: files, DryRun: dryRun})
+	if err == nil && !dryRun && len(report.Steps) > 0 {
+		slog.Info("upgraded on-disk layout", "from", report.From, "to", report.To, "backup", report.Backup)
+	}
+	return report, err
+}
@@ -0,0 +1,95 @@
+package fileserver
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/dto"
+	"github.com/Skpow1234/Peervault/internal/messaging"
+)
+
+// messenger carries the application messages of the node's mailbox to the
+// peers on the ring, by node ID
+type messenger struct{ server *Server }
+
+func (m messenger) SendMessage(node string, msg messaging.Message) error {
+	addr, ok := m.server.nodeAddr(node)
+	if !ok {
+		return fmt.Errorf("%w: %s", messaging.ErrUnreachable, node)
+	}
+	return m.server.send(addr, &Message{Payload: dto.AppMessage{
+		ID: msg.ID, From: msg.From, To: msg.To, Channel: msg.Channel, Payload: msg.Payload,
+		SentAt: msg.SentAt.UnixNano(), ExpiresAt: msg.ExpiresAt.UnixNano(),
+	}})
+}
+
+func (m messenger) SendReceipt(node string, r messaging.Receipt) error {
+	addr, ok := m.server.nodeAddr(node)
+	if !ok {
+		return fmt.Errorf("%w: %s", messaging.ErrUnreachable, node)
+	}
+	return m.server.send(addr, &Message{Payload: receiptMessage(r)})
+}
+
+func receiptMessage(r messaging.Receipt) dto.AppReceipt {
+	return dto.AppReceipt{
+		MessageID: r.MessageID, Node: r.Node, Channel: r.Channel, Status: string(r.Status),
+		Time: r.Time.UnixNano(), Error: r.Error,
+	}
+}
+
+// nodeAddr returns the address of the peer with the given node ID
+func (s *Server) nodeAddr(node string) (string, bool) {
+	p := s.placement
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	addr, ok := p.addrs[node]
+	return addr, ok
+}
+
+// handleMessageAppMessage hands an application message to the mailbox,
+// which answers the sender; nodes without one reject it
+func (s *Server) handleMessageAppMessage(from string, msg dto.AppMessage) error {
+	if s.Messaging == nil {
+		return s.send(from, &Message{Payload: receiptMessage(messaging.Receipt{
+			MessageID: msg.ID, Node: s.ID, Channel: msg.Channel, Status: messaging.Rejected,
+			Time: time.Now(), Error: "messaging is not enabled",
+		})})
+	}
+	s.Messaging.HandleMessage(messaging.Message{
+		ID: msg.ID, From: msg.From, To: msg.To, Channel: msg.Channel, Payload: msg.Payload,
+		SentAt: time.Unix(0, msg.SentAt), ExpiresAt: time.Unix(0, msg.ExpiresAt),
+	})
+	return nil
+}
+
+func (s *Server) handleMessageAppReceipt(msg dto.AppReceipt) {
+	if s.Messaging == nil {
+		return
+	}
+	s.Messaging.HandleReceipt(messaging.Receipt{
+		MessageID: msg.MessageID, Node: msg.Node, Channel: msg.Channel, Status: messaging.Status(msg.Status),
+		Time: time.Unix(0, msg.Time), Error: msg.Error,
+	})
+}
+
+// retryMessages sends the messages waiting in the outbox again and
+// persists the mailbox every retry interval until the server stops, which
+// persists it a last time. Messages for a node are also sent as soon as it
+// joins the ring.
+func (s *Server) retryMessages() {
+	ticker := time.NewTicker(s.Messaging.RetryInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Messaging.Retry("")
+			if err := s.Messaging.Flush(); err != nil {
+				slog.Warn("failed to persist application messages", "error", err)
+			}
+		case <-s.quitch:
+			return
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package fileserver
+
+import (
+	"context"
+	"runtime"
+	"runtime/metrics"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// metricSampleInterval is how often node metrics are sampled and alert
+	// rules evaluated
+	metricSampleInterval = 15 * time.Second
+	// metricHistoryRetention is how long sampled metrics are kept
+	metricHistoryRetention = 24 * time.Hour
+)
+
+// MetricPoint is the value of a metric at a time
+type MetricPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// metricHistory keeps the samples of the node's metrics for the retention
+type metricHistory struct {
+	mu     sync.Mutex
+	series map[string][]MetricPoint
+}
+
+func newMetricHistory() *metricHistory {
+	return &metricHistory{series: make(map[string][]MetricPoint)}
+}
+
+func (h *metricHistory) record(values map[string]float64, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cutoff := at.Add(-metricHistoryRetention)
+	for name, v := range values {
+		points := append(h.series[name], MetricPoint{Time: at, Value: v})
+		drop := 0
+		for drop < len(points) && points[drop].Time.Before(cutoff) {
+			drop++
+		}
+		if drop > 0 {
+			points = append([]MetricPoint(nil), points[drop:]...)
+		}
+		h.series[name] = points
+	}
+}
+
+// Metrics samples the node's metrics alert rules and dashboards can refer
+// to. Access counters count since the node started.
+func (s *Server) Metrics() map[string]float64 {
+	s.peerLock.RLock()
+	peers := len(s.peers)
+	s.peerLock.RUnlock()
+
+	heap := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(heap)
+
+	m := map[string]float64{
+		"peers":      float64(peers),
+		"ring_nodes": float64(s.Ring().Len()),
+		"conflicts":  float64(len(s.Conflicts())),
+		"documents":  float64(len(s.Documents())),
+		"goroutines": float64(runtime.NumGoroutine()),
+
+		"swarm_downloads_total":     float64(s.swarm.downloaded.Load()),
+		"swarm_pieces_served_total": float64(s.swarm.piecesServed.Load()),
+	}
+	if sp := s.Space(); !sp.CheckedAt.IsZero() {
+		m["storage_capacity_bytes"] = float64(sp.Capacity)
+		m["storage_used_bytes"] = float64(sp.Used)
+		m["storage_free_bytes"] = float64(sp.Free)
+	}
+	if heap[0].Value.Kind() == metrics.KindUint64 {
+		m["heap_bytes"] = float64(heap[0].Value.Uint64())
+	}
+	if s.ReadCache != nil {
+		stats := s.ReadCache.Stats()
+		m["read_cache_hits_total"] = float64(stats.Hits)
+		m["read_cache_misses_total"] = float64(stats.Misses)
+		m["read_cache_evictions_total"] = float64(stats.Evictions)
+		m["read_cache_hit_ratio"] = stats.HitRatio
+		m["read_cache_bytes"] = float64(stats.Bytes)
+	}
+	if s.Edge != nil {
+		stats := s.Edge.Stats()
+		m["edge_pulled_files"] = float64(stats.Files)
+		m["edge_pulled_bytes"] = float64(stats.Bytes)
+		m["edge_pulls_total"] = float64(stats.Pulls)
+		m["edge_revalidations_total"] = float64(stats.Revalidations)
+		m["edge_stale_served_total"] = float64(stats.StaleServed)
+		m["edge_expired_total"] = float64(stats.Expired)
+	}
+	if s.Analytics != nil {
+		totals := s.Analytics.Totals()
+		m["reads_total"] = float64(totals.Reads)
+		m["writes_total"] = float64(totals.Writes)
+		m["deletes_total"] = float64(totals.Deletes)
+		m["bytes_read_total"] = float64(totals.BytesRead)
+		m["bytes_written_total"] = float64(totals.BytesWritten)
+	}
+	if s.Hooks != nil {
+		for _, h := range s.Hooks.Stats() {
+			prefix := "hook_" + strings.ReplaceAll(h.Name, "-", "_")
+			m[prefix+"_calls_total"] = float64(h.Calls)
+			m[prefix+"_rejections_total"] = float64(h.Rejections)
+			m[prefix+"_errors_total"] = float64(h.Errors)
+			m[prefix+"_timeouts_total"] = float64(h.Timeouts)
+			m[prefix+"_seconds_total"] = h.Duration.Seconds()
+		}
+	}
+	return m
+}
+
+// MetricNames returns the names of the sampled metrics in order
+func (s *Server) MetricNames() []string {
+	s.metrics.mu.Lock()
+	defer s.metrics.mu.Unlock()
+	names := make([]string, 0, len(s.metrics.series))
+	for name := range s.metrics.series {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// MetricHistory returns the samples of a metric taken in [from, to],
+// oldest first. Samples are kept for a day.
+func (s *Server) MetricHistory(name string, from, to time.Time) []MetricPoint {
+	s.metrics.mu.Lock()
+	defer s.metrics.mu.Unlock()
+	var points []MetricPoint
+	for _, p := range s.metrics.series[name] {
+		if !p.Time.Before(from) && !p.Time.After(to) {
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+// sampleMetrics samples the node's metrics into the history, and evaluates
+// the alert rules over them, until the server stops
+func (s *Server) sampleMetrics() {
+	ticker := time.NewTicker(metricSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			values := s.Metrics()
+			s.metrics.record(values, now)
+			if s.Alerts != nil {
+				s.Alerts.Observe(values, now)
+				s.Alerts.Evaluate(context.Background(), now)
+			}
+		case <-s.quitch:
+			return
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package fileserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"github.com/Skpow1234/Peervault/internal/events"
+	"github.com/Skpow1234/Peervault/internal/watch"
+	"github.com/Skpow1234/Peervault/pkg/plugin"
+)
+
+// sinkBuffer is how many events of the bus a plugin's event sink may fall
+// behind before it misses some
+const sinkBuffer = 256
+
+// forwardEvents hands the changes to files made through this node and the
+// events of the bus to the event sink of a plugin until the server stops.
+// Delivery is best effort: events the sink fails on are logged and
+// dropped, and a sink that falls too far behind misses events.
+func (s *Server) forwardEvents(name string, sink plugin.EventSink) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-s.quitch
+		cancel()
+	}()
+
+	bus, unsubscribe := s.Events.Subscribe(sinkBuffer)
+	defer unsubscribe()
+	token := s.changes.Token()
+	changes, err := s.changes.Watch(ctx, "", token)
+	if err != nil {
+		slog.Warn("failed to watch changes for plugin", "plugin", name, "error", err)
+		return
+	}
+
+	deliver := func(e plugin.Event) {
+		if err := sink.Handle(ctx, e); err != nil && ctx.Err() == nil {
+			slog.Warn("plugin failed to handle event", "plugin", name, "type", e.Type, "key", e.Key, "error", err)
+		}
+	}
+	for {
+		select {
+		case change, ok := <-changes:
+			if ok {
+				token = change.Token
+				deliver(changeEvent(change))
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			// The watch was closed because the sink fell behind; resume
+			// after the last change delivered, or from now when the
+			// journal no longer holds it
+			changes, err = s.changes.Watch(ctx, "", token)
+			if errors.Is(err, watch.ErrExpired) {
+				slog.Warn("plugin fell behind and missed changes", "plugin", name)
+				changes, err = s.changes.Watch(ctx, "", s.changes.Token())
+			}
+			if err != nil {
+				slog.Warn("failed to watch changes for plugin", "plugin", name, "error", err)
+				return
+			}
+		case e, ok := <-bus:
+			if !ok {
+				return
+			}
+			deliver(busEvent(e))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// changeEvent describes a change to a file as a "file.created",
+// "file.updated" or "file.deleted" event
+func changeEvent(c watch.Event) plugin.Event {
+	return plugin.Event{Type: "file." + string(c.Op), Key: c.Key, Size: c.Size, Time: c.Time}
+}
+
+// busEvent describes an event of the bus, its data encoded as JSON
+func busEvent(e events.Event) plugin.Event {
+	out := plugin.Event{Type: e.Type, Key: e.Key, Time: e.Time}
+	if e.Data != nil {
+		if data, err := json.Marshal(e.Data); err == nil {
+			out.Data = data
+		}
+	}
+	return out
+}
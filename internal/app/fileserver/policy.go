@@ -0,0 +1,58 @@
+package fileserver
+
+import (
+	"github.com/Skpow1234/Peervault/internal/policy"
+	"github.com/Skpow1234/Peervault/internal/scan"
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+)
+
+// policyInput describes an operation on a file to the policy. The tenant is
+// the "tenant" metadata entry, or else the owner recorded in the metadata
+// store, which also supplies the tags and metadata of replicated files.
+func (s *Server) policyInput(op policy.Operation, key string, data []byte, tags []string, attrs map[string]string) policy.Input {
+	in := policy.Input{
+		Operation:   op,
+		Key:         key,
+		Size:        int64(len(data)),
+		ContentType: scan.Sniff(data),
+		Tenant:      attrs["tenant"],
+		Tags:        tags,
+		Metadata:    attrs,
+		Node:        policy.Peer{ID: s.ID, Addr: s.Transport.Addr()},
+	}
+	if s.Metadata != nil {
+		if rec, err := s.Metadata.Get(key); err == nil {
+			if in.Tenant == "" {
+				in.Tenant = rec.Owner
+			}
+			if in.Tags == nil && in.Metadata == nil {
+				in.Tags, in.Metadata = rec.Tags, rec.Metadata
+			}
+		}
+	}
+	return in
+}
+
+// checkStore evaluates the policy on storing a file
+func (s *Server) checkStore(key string, data []byte, tags []string, attrs map[string]string) error {
+	if s.Policy == nil {
+		return nil
+	}
+	return s.Policy.Check(s.policyInput(policy.OpStore, key, data, tags, attrs))
+}
+
+// checkReplica evaluates the policy on copying a file to the peer at addr,
+// which places replicas only where the rules allow, e.g. in a region
+func (s *Server) checkReplica(addr, key string, data []byte, tags []string, attrs map[string]string) error {
+	if s.Policy == nil {
+		return nil
+	}
+	in := s.policyInput(policy.OpReplicate, key, data, tags, attrs)
+	in.Peer = policy.Peer{ID: addr, Addr: addr}
+	s.peerLock.RLock()
+	if tcp, ok := s.peers[addr].(*netp2p.TCPPeer); ok && tcp.NodeID() != "" {
+		in.Peer.ID = tcp.NodeID()
+	}
+	s.peerLock.RUnlock()
+	return s.Policy.Check(in)
+}
@@ -0,0 +1,126 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/Skpow1234/Peervault/internal/analytics"
+	"github.com/Skpow1234/Peervault/internal/edge"
+	"github.com/Skpow1234/Peervault/internal/watch"
+)
+
+// revalidatePulled refreshes the local copy of a file pulled from the
+// origin once its TTL ran out. handled is false when the local copy is to
+// be served: it is fresh, unchanged at the origin, or the origin could not
+// be reached.
+func (s *Server) revalidatePulled(ctx context.Context, key string) (r io.Reader, handled bool, err error) {
+	if s.Edge == nil {
+		return nil, false, nil
+	}
+	if _, fresh, ok := s.Edge.Lookup(key); !ok || fresh {
+		return nil, false, nil
+	}
+
+	data, etag, err := s.Edge.Fetch(ctx, key)
+	switch {
+	case errors.Is(err, edge.ErrNotModified):
+		if err := s.Edge.Revalidated(key); err != nil {
+			slog.Warn("failed to persist pulled files", "error", err)
+		}
+		return nil, false, nil
+	case errors.Is(err, edge.ErrOriginNotFound):
+		slog.Info("pulled file deleted at the origin", "key", key)
+		s.dropPulled(key)
+		return nil, true, fmt.Errorf("file not found on any peer")
+	case err != nil:
+		if ctx.Err() != nil {
+			return nil, true, ctx.Err()
+		}
+		slog.Warn("origin unreachable, serving stale copy", "key", key, "error", err)
+		s.Edge.ServedStale()
+		return nil, false, nil
+	}
+	return s.keepPulled(key, data, etag), true, nil
+}
+
+// pull fetches a file the node and its peers do not have from the origin
+// and keeps a copy of it
+func (s *Server) pull(ctx context.Context, key string) (io.Reader, error) {
+	data, etag, err := s.Edge.Fetch(ctx, key)
+	if errors.Is(err, edge.ErrOriginNotFound) {
+		return nil, fmt.Errorf("file not found on any peer or at the origin")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to pull file from the origin: %w", err)
+	}
+	slog.Info("pulled file from the origin", "key", key, "size", len(data))
+	return s.keepPulled(key, data, etag), nil
+}
+
+// keepPulled stores a file pulled from the origin and returns its content.
+// Pulled files are kept on this node only, not placed on the ring, so each
+// edge node keeps what its own clients ask for. The content is served even
+// when it cannot be kept.
+func (s *Server) keepPulled(key string, data []byte, etag string) io.Reader {
+	s.recordAccess(analytics.OpRead, key, "", int64(len(data)))
+	if err := s.checkSpace(key); err != nil {
+		slog.Warn("not keeping pulled file", "key", key, "error", err)
+		return bytes.NewReader(data)
+	}
+	// The store does not overwrite, so a changed file replaces the old copy
+	op := watch.Created
+	if s.store.Has(key) {
+		op = watch.Updated
+		if err := s.deleteStored(key); err != nil {
+			slog.Warn("failed to replace pulled file", "key", key, "error", err)
+			return bytes.NewReader(data)
+		}
+	}
+	size, err := s.writeEncrypted(key, bytes.NewReader(data))
+	if err != nil {
+		slog.Warn("failed to keep pulled file", "key", key, "error", err)
+		return bytes.NewReader(data)
+	}
+	s.consumeSpace(size)
+	s.changes.Record(op, key, int64(len(data)))
+	if err := s.Edge.Pulled(key, etag, int64(len(data))); err != nil {
+		slog.Warn("failed to persist pulled files", "error", err)
+	}
+	return bytes.NewReader(data)
+}
+
+// dropPulled deletes the local copy of a pulled file
+func (s *Server) dropPulled(key string) {
+	if err := s.deleteStored(key); err != nil {
+		slog.Warn("failed to delete pulled file", "key", key, "error", err)
+		return
+	}
+	s.changes.Record(watch.Deleted, key, 0)
+	s.forgetPulled(key)
+}
+
+// forgetPulled stops treating the local copy of key as the origin's
+func (s *Server) forgetPulled(key string) {
+	if s.Edge == nil {
+		return
+	}
+	if err := s.Edge.Forget(key); err != nil {
+		slog.Warn("failed to persist pulled files", "error", err)
+	}
+}
+
+// expirePulled deletes the pulled files no one asked for during a TTL
+// after they went stale
+func (s *Server) expirePulled(ctx context.Context) error {
+	for _, key := range s.Edge.Expired() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.dropPulled(key)
+	}
+	return nil
+}
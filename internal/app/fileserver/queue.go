@@ -0,0 +1,69 @@
+package fileserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/Skpow1234/Peervault/internal/notify"
+	"github.com/Skpow1234/Peervault/internal/queue"
+)
+
+// replicateJobKind is the kind of queued jobs pushing a replica an owner
+// of a file missed when it was stored
+const replicateJobKind = "replicate"
+
+// replicateJob is the payload of replication jobs
+type replicateJob struct {
+	HashedKey  string            `json:"hashed_key"`
+	StorageKey string            `json:"storage_key"`
+	Node       string            `json:"node"`
+	Tags       []string          `json:"tags,omitempty"`
+	Attrs      map[string]string `json:"attrs,omitempty"`
+}
+
+// registerQueueHandlers runs the queued work of the server
+func (s *Server) registerQueueHandlers() error {
+	if err := s.Queue.Handle(replicateJobKind, s.retryReplica); err != nil {
+		return err
+	}
+	if s.rotation != nil {
+		if err := s.Queue.Handle(reencryptJobKind, s.rotation.retryObject); err != nil {
+			return err
+		}
+	}
+	return notify.HandleWebhooks(s.Queue, nil)
+}
+
+// queueReplica retries pushing a replica to the node at addr later
+func (s *Server) queueReplica(addr, hashedKey, storageKey string, tags []string, attrs map[string]string) {
+	node, ok := s.addrNode(addr)
+	if !ok {
+		return
+	}
+	job := replicateJob{HashedKey: hashedKey, StorageKey: storageKey, Node: node, Tags: tags, Attrs: attrs}
+	if _, err := s.Queue.Enqueue(replicateJobKind, job, queue.EnqueueOptions{Key: hashedKey + "@" + node, Priority: 1}); err != nil {
+		slog.Warn("failed to queue replication", "key", storageKey, "node", node, "error", err)
+	}
+}
+
+// retryReplica pushes a queued replica to its node while the node is
+// connected, still owns the file and this node still has it. Nodes that
+// disconnected left the ring, and the rebalance copies their files when
+// they rejoin.
+func (s *Server) retryReplica(ctx context.Context, payload json.RawMessage) error {
+	var job replicateJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return fmt.Errorf("%w: %v", queue.ErrPermanent, err)
+	}
+	if !s.store.Has(job.StorageKey) {
+		return nil
+	}
+	addr, ok := s.nodeAddr(job.Node)
+	if !ok || !slices.Contains(s.ownerAddrs(job.HashedKey), addr) {
+		return nil
+	}
+	return s.push(ctx, addr, job.HashedKey, job.StorageKey, job.Tags, job.Attrs)
+}
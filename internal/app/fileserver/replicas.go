@@ -0,0 +1,260 @@
+package fileserver
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/dto"
+	"github.com/Skpow1234/Peervault/internal/peer"
+)
+
+// DefaultReplicationFactor is the number of copies of a file, this node's
+// included, that makes it redundant
+const DefaultReplicationFactor = 3
+
+// replicaQueryTimeout bounds how long Replicas waits for peers to answer
+const replicaQueryTimeout = 3 * time.Second
+
+// ReplicaStatus is the state of one copy of a file
+type ReplicaStatus string
+
+const (
+	// ReplicaHealthy copies were confirmed by a healthy peer
+	ReplicaHealthy ReplicaStatus = "healthy"
+	// ReplicaDegraded copies are held by a peer failing health checks
+	ReplicaDegraded ReplicaStatus = "degraded"
+	// ReplicaSizeMismatch copies differ in size from this node's copy
+	ReplicaSizeMismatch ReplicaStatus = "size_mismatch"
+	// ReplicaMissing copies were held by a peer that no longer has them
+	ReplicaMissing ReplicaStatus = "missing"
+	// ReplicaUnreachable copies are held by a peer that did not answer
+	ReplicaUnreachable ReplicaStatus = "unreachable"
+)
+
+// Replica is one copy of a file
+type Replica struct {
+	// Node is the node ID of the holder
+	Node  string `json:"node"`
+	Addr  string `json:"addr"`
+	Local bool   `json:"local,omitempty"`
+	// Status is healthy only for copies confirmed by the last check
+	Status ReplicaStatus `json:"status"`
+	Size   int64         `json:"size"`
+	// LastVerified is when the holder last confirmed the copy
+	LastVerified time.Time `json:"last_verified"`
+}
+
+// ReplicaReport tells where the copies of a file are and whether there
+// are enough of them
+type ReplicaReport struct {
+	Key               string `json:"key"`
+	ReplicationFactor int    `json:"replication_factor"`
+	// Healthy counts the healthy copies, this node's included
+	Healthy int `json:"healthy"`
+	// Satisfied is true when Healthy reaches ReplicationFactor
+	Satisfied bool      `json:"satisfied"`
+	Replicas  []Replica `json:"replicas"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// replicaTracker matches HasFile answers to queries and remembers the
+// peers that held each file, so copies that go missing or unreachable
+// still show up
+type replicaTracker struct {
+	mu      sync.Mutex
+	queries map[string]chan hasFileAnswer // by request ID
+	known   map[string]map[string]Replica // by key, then node ID
+}
+
+type hasFileAnswer struct {
+	addr string
+	ack  dto.HasFileAck
+}
+
+func newReplicaTracker() *replicaTracker {
+	return &replicaTracker{
+		queries: make(map[string]chan hasFileAnswer),
+		known:   make(map[string]map[string]Replica),
+	}
+}
+
+// deliver hands an answer to the query waiting for it; answers to queries
+// that already finished are dropped
+func (t *replicaTracker) deliver(addr string, ack dto.HasFileAck) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ch, ok := t.queries[ack.RequestID]; ok {
+		select {
+		case ch <- hasFileAnswer{addr: addr, ack: ack}:
+		default:
+		}
+	}
+}
+
+// forget drops what is known about the copies of a file
+func (t *replicaTracker) forget(key string) {
+	t.mu.Lock()
+	delete(t.known, key)
+	t.mu.Unlock()
+}
+
+// replicationFactor returns the configured replication factor
+func (s *Server) replicationFactor() int {
+	if s.ReplicationFactor > 0 {
+		return s.ReplicationFactor
+	}
+	return DefaultReplicationFactor
+}
+
+// Replicas asks every connected peer whether it holds the file and
+// reports the copies found, together with copies seen by earlier checks
+// that are now missing or unreachable. Sizes are compared with this
+// node's copy when it has one.
+func (s *Server) Replicas(ctx context.Context, key string) (ReplicaReport, error) {
+	now := time.Now()
+	report := ReplicaReport{Key: key, ReplicationFactor: s.replicationFactor(), Replicas: []Replica{}, CheckedAt: now}
+
+	localSize := int64(-1)
+	if storageKey, ok := s.localKey(key); ok {
+		size, r, err := s.store.Read(storageKey)
+		if err != nil {
+			return ReplicaReport{}, err
+		}
+		_ = r.Close()
+		localSize = size
+		report.Replicas = append(report.Replicas, Replica{
+			Node: s.ID, Addr: s.Transport.Addr(), Local: true, Status: ReplicaHealthy, Size: size, LastVerified: now,
+		})
+	}
+
+	// Peers store files under the hashed key
+	answers, asked, err := s.queryReplicas(ctx, crypto.HashKey(key), s.peerAddrs())
+	if err != nil {
+		return ReplicaReport{}, err
+	}
+
+	s.replicas.mu.Lock()
+	known := s.replicas.known[key]
+	if known == nil {
+		known = make(map[string]Replica)
+	}
+	seen := make(map[string]bool, len(answers))
+	for _, a := range answers {
+		node := cmp.Or(a.ack.ID, a.addr)
+		seen[node] = true
+		if !a.ack.HasFile {
+			if prev, ok := known[node]; ok {
+				prev.Status, prev.Addr = ReplicaMissing, a.addr
+				known[node] = prev
+			}
+			continue
+		}
+		status := ReplicaHealthy
+		if localSize >= 0 && a.ack.Size != localSize {
+			status = ReplicaSizeMismatch
+		} else if health, ok := s.peerHealth(a.addr); ok && health != peer.StatusHealthy {
+			status = ReplicaDegraded
+		}
+		known[node] = Replica{Node: node, Addr: a.addr, Status: status, Size: a.ack.Size, LastVerified: now}
+	}
+	for node, r := range known {
+		if !seen[node] && r.Status != ReplicaMissing {
+			r.Status = ReplicaUnreachable
+			known[node] = r
+		}
+	}
+	if len(known) > 0 {
+		s.replicas.known[key] = known
+	}
+	for _, r := range known {
+		report.Replicas = append(report.Replicas, r)
+	}
+	s.replicas.mu.Unlock()
+
+	slices.SortFunc(report.Replicas, func(a, b Replica) int {
+		if a.Local != b.Local {
+			if a.Local {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(a.Node, b.Node)
+	})
+	for _, r := range report.Replicas {
+		if r.Status == ReplicaHealthy {
+			report.Healthy++
+		}
+	}
+	report.Satisfied = report.Healthy >= report.ReplicationFactor
+
+	slog.Debug("checked replicas", "key", key, "asked", asked, "answered", len(answers), "healthy", report.Healthy)
+	return report, nil
+}
+
+// queryReplicas sends HasFile to the peers at addrs and collects the
+// answers until all peers answered, the timeout passed or ctx is done
+func (s *Server) queryReplicas(ctx context.Context, hashedKey string, addrs []string) ([]hasFileAnswer, int, error) {
+	if len(addrs) == 0 {
+		return nil, 0, nil
+	}
+
+	requestID := crypto.GenerateID()
+	ch := make(chan hasFileAnswer, len(addrs))
+	s.replicas.mu.Lock()
+	s.replicas.queries[requestID] = ch
+	s.replicas.mu.Unlock()
+	defer func() {
+		s.replicas.mu.Lock()
+		delete(s.replicas.queries, requestID)
+		s.replicas.mu.Unlock()
+	}()
+
+	asked := 0
+	for _, addr := range addrs {
+		if err := s.send(addr, &Message{Payload: dto.HasFile{RequestID: requestID, Key: hashedKey}}); err != nil {
+			slog.Warn("failed to ask peer for replica", "peer", addr, "error", err)
+			continue
+		}
+		asked++
+	}
+
+	// Peers that do not answer in time are reported as unreachable
+	timeout := time.NewTimer(replicaQueryTimeout)
+	defer timeout.Stop()
+	answers := make([]hasFileAnswer, 0, asked)
+	for len(answers) < asked {
+		select {
+		case a := <-ch:
+			answers = append(answers, a)
+		case <-timeout.C:
+			return answers, asked, nil
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+	return answers, asked, nil
+}
+
+// peerHealth returns the health of the peer at addr as seen by the health
+// manager
+func (s *Server) peerHealth(addr string) (peer.HealthStatus, bool) {
+	if s.healthManager == nil {
+		return 0, false
+	}
+	return s.healthManager.GetPeerStatus(addr)
+}
+
+// handleMessageHasFile tells a peer whether this node holds a file
+func (s *Server) handleMessageHasFile(from string, msg dto.HasFile) error {
+	ack := dto.HasFileAck{RequestID: msg.RequestID, ID: s.ID, Key: msg.Key}
+	if size, r, err := s.store.Read(s.placement.storageKey(msg.Key)); err == nil {
+		_ = r.Close()
+		ack.HasFile, ack.Size = true, size
+	}
+	return s.send(from, &Message{Payload: ack})
+}
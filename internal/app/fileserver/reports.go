@@ -0,0 +1,48 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/analytics"
+)
+
+// ErrReportsDisabled is returned when the server has no reports
+var ErrReportsDisabled = errors.New("fileserver: reports are not enabled")
+
+// RunReport runs an analytics report now, storing its output in the
+// cluster like any other file
+func (s *Server) RunReport(ctx context.Context, name string) (*analytics.ReportRun, error) {
+	if s.Reports == nil {
+		return nil, ErrReportsDisabled
+	}
+	return s.Reports.Run(ctx, name, s.storeBytes)
+}
+
+// storeBytes stores the output of a report or export like any other file
+func (s *Server) storeBytes(ctx context.Context, key string, data []byte) error {
+	return s.Store(ctx, key, bytes.NewReader(data))
+}
+
+// runDueReports runs the scheduled reports that are due, failing with
+// those that could not be run
+func (s *Server) runDueReports(ctx context.Context) error {
+	var errs []error
+	for _, name := range s.Reports.Due(time.Now()) {
+		run, err := s.RunReport(ctx, name)
+		switch {
+		case err != nil:
+			slog.Warn("scheduled report failed", "report", name, "error", err)
+			errs = append(errs, fmt.Errorf("report %s: %w", name, err))
+		case len(run.Errors) > 0:
+			slog.Warn("scheduled report not delivered", "report", name, "key", run.Key, "errors", run.Errors)
+		default:
+			slog.Info("scheduled report stored", "report", name, "key", run.Key)
+		}
+	}
+	return errors.Join(errs...)
+}
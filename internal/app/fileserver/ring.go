@@ -0,0 +1,386 @@
+package fileserver
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/dto"
+	"github.com/Skpow1234/Peervault/internal/hashring"
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+)
+
+// DefaultCapacity is the storage capacity assumed for nodes that do not
+// configure one. A node's weight on the hash ring is its capacity relative
+// to DefaultCapacity.
+const DefaultCapacity = 100 << 30
+
+// rebalanceDelay lets a burst of membership changes, such as a node
+// connecting to every peer, settle before data moves
+const rebalanceDelay = 2 * time.Second
+
+// placement decides which nodes own which keys. Keys are placed on a
+// consistent hashing ring by their hashed key, which every node knows, and
+// owned by the first ReplicationFactor nodes. Nodes are weighted by their
+// free space, and full nodes are passed over for new copies. When the ring
+// changes only the keys whose owners changed are copied, to their new
+// owners.
+type placement struct {
+	mu    sync.Mutex
+	ring  *hashring.Ring
+	addrs map[string]string // peer address by node ID
+	// nodes are the last introductions of the peers on the ring
+	nodes map[string]dto.NodeInfo
+	// held are the local copies by hashed key
+	held  map[string]localCopy
+	timer *time.Timer
+
+	// rebalancing serializes rebalances
+	rebalancing sync.Mutex
+}
+
+// localCopy is a file held by this node
+type localCopy struct {
+	// storageKey is the key the copy is stored under: the hashed key for
+	// copies received from peers, the plain key for files stored on this
+	// node
+	storageKey string
+	// ring is the ring the copy was placed for
+	ring *hashring.Ring
+}
+
+// capacityWeight converts a storage capacity to a ring weight
+func capacityWeight(capacity int64) float64 {
+	if capacity <= 0 {
+		return 1
+	}
+	return float64(capacity) / DefaultCapacity
+}
+
+// initializePlacement puts this node alone on the ring
+func (s *Server) initializePlacement() {
+	ring := hashring.New(s.VirtualNodes, hashring.Node{ID: s.ID, Weight: capacityWeight(s.Capacity)})
+	s.placement = &placement{
+		ring:  ring,
+		addrs: make(map[string]string),
+		nodes: make(map[string]dto.NodeInfo),
+		held:  make(map[string]localCopy),
+	}
+}
+
+// Ring returns the current hash ring
+func (s *Server) Ring() *hashring.Ring {
+	s.placement.mu.Lock()
+	defer s.placement.mu.Unlock()
+	return s.placement.ring
+}
+
+// hold records a local copy placed for the current ring
+func (p *placement) hold(hashedKey, storageKey string) {
+	p.mu.Lock()
+	p.held[hashedKey] = localCopy{storageKey: storageKey, ring: p.ring}
+	p.mu.Unlock()
+}
+
+// keep records a local copy placed for the current ring unless the copy is
+// held already, so a copy received while the ring changes is still moved
+func (p *placement) keep(hashedKey, storageKey string) {
+	p.mu.Lock()
+	if _, ok := p.held[hashedKey]; !ok {
+		p.held[hashedKey] = localCopy{storageKey: storageKey, ring: p.ring}
+	}
+	p.mu.Unlock()
+}
+
+// release forgets a local copy
+func (p *placement) release(hashedKey string) {
+	p.mu.Lock()
+	delete(p.held, hashedKey)
+	p.mu.Unlock()
+}
+
+// storageKey returns the key the local copy of a file is stored under
+func (p *placement) storageKey(hashedKey string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.held[hashedKey]; ok {
+		return c.storageKey
+	}
+	return hashedKey
+}
+
+// announce introduces this node to a new peer
+func (s *Server) announce(p netp2p.Peer) error {
+	return s.send(p.RemoteAddr().String(), &Message{Payload: s.nodeInfo()})
+}
+
+// nodeInfo is how this node introduces itself
+func (s *Server) nodeInfo() dto.NodeInfo {
+	s.space.mu.Lock()
+	sp := s.space.announced
+	s.space.mu.Unlock()
+	info := dto.NodeInfo{
+		ID: s.ID, Capacity: s.Capacity, Leaving: s.leaving(), Free: sp.Free, Full: sp.Full,
+		Region: s.Region, URL: s.PublicURL,
+	}
+	if s.Tasks != nil {
+		info.Tasks = s.Tasks.Handlers()
+	}
+	if s.Location != nil {
+		info.Latitude, info.Longitude, info.Located = s.Location.Lat, s.Location.Lon, true
+	}
+	return info
+}
+
+// handleMessageNodeInfo places a peer on the ring, or takes it off when it
+// is leaving the cluster
+func (s *Server) handleMessageNodeInfo(from string, msg dto.NodeInfo) {
+	if msg.ID == s.ID {
+		// This node dialed itself, as through a seed listing it
+		if peer, ok := s.getPeer(from); ok {
+			slog.Info("dropping connection to self", "peer", from)
+			peer.Close()
+		}
+		return
+	}
+	if msg.ID == "" {
+		return
+	}
+	p := s.placement
+	p.mu.Lock()
+	if msg.Leaving {
+		// The node stays connected to hand its files over, but owns none
+		delete(p.addrs, msg.ID)
+		delete(p.nodes, msg.ID)
+		ring := p.ring.Without(msg.ID)
+		changed := ring != p.ring
+		p.ring = ring
+		p.mu.Unlock()
+		if changed {
+			slog.Info("node is leaving the ring", "node", msg.ID, "peer", from, "nodes", ring.Len())
+			s.scheduleRebalance()
+		}
+		if s.Tasks != nil {
+			s.Tasks.Advertise(msg.ID, nil)
+		}
+		return
+	}
+	p.addrs[msg.ID] = from
+	if prev, ok := p.nodes[msg.ID]; ok && prev.Full != msg.Full {
+		slog.Info("node changed whether it takes new copies", "node", msg.ID, "full", msg.Full, "free", msg.Free)
+	}
+	p.nodes[msg.ID] = msg
+	ring := p.ring.With(hashring.Node{ID: msg.ID, Weight: nodeWeight(msg)})
+	changed := ring != p.ring
+	p.ring = ring
+	p.mu.Unlock()
+
+	if changed {
+		slog.Info("node joined the ring", "node", msg.ID, "peer", from, "nodes", ring.Len())
+		s.scheduleRebalance()
+	}
+	if s.Messaging != nil {
+		// Send the messages that waited for the node
+		go s.Messaging.Retry(msg.ID)
+	}
+	if s.Tasks != nil {
+		s.Tasks.Advertise(msg.ID, msg.Tasks)
+	}
+}
+
+// removeFromRing takes the node reached at addr off the ring
+func (s *Server) removeFromRing(addr string) {
+	p := s.placement
+	p.mu.Lock()
+	var gone string
+	for id, a := range p.addrs {
+		if a == addr {
+			gone = id
+			break
+		}
+	}
+	if gone == "" {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.addrs, gone)
+	delete(p.nodes, gone)
+	p.ring = p.ring.Without(gone)
+	nodes := p.ring.Len()
+	p.mu.Unlock()
+
+	slog.Info("node left the ring", "node", gone, "peer", addr, "nodes", nodes)
+	s.scheduleRebalance()
+	if s.Tasks != nil {
+		s.Tasks.DropNode(gone)
+	}
+}
+
+// ownerAddrs returns the addresses of the peers owning a key, in ring
+// order; this node is left out. Full peers are passed over for the next
+// nodes on the ring.
+func (s *Server) ownerAddrs(hashedKey string) []string {
+	p := s.placement
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	full := 0
+	for _, info := range p.nodes {
+		if info.Full {
+			full++
+		}
+	}
+	var addrs []string
+	owners := 0
+	for _, id := range p.ring.Owners(hashedKey, s.replicationFactor()+full) {
+		if owners == s.replicationFactor() {
+			break
+		}
+		if p.nodes[id].Full {
+			continue
+		}
+		owners++
+		if addr, ok := p.addrs[id]; ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// fetchOrder returns the peers to ask for a file: its owners first, then
+// the other peers, each group nearest first
+func (s *Server) fetchOrder(hashedKey string) []string {
+	owners := s.ownerAddrs(hashedKey)
+	others := slices.DeleteFunc(s.peerAddrs(), func(addr string) bool { return slices.Contains(owners, addr) })
+	s.latency.SortByLatency(owners)
+	s.latency.SortByLatency(others)
+	return append(owners, others...)
+}
+
+// replicate pushes a new version of the local copy stored under
+// storageKey to the peers that own it
+func (s *Server) replicate(ctx context.Context, hashedKey, storageKey string, tags []string, attrs map[string]string) {
+	owners := s.ownerAddrs(hashedKey)
+
+	var wg sync.WaitGroup
+	for _, addr := range owners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.push(ctx, addr, hashedKey, storageKey, tags, attrs); err != nil {
+				slog.Warn("failed to replicate file, retrying in the background", "key", storageKey, "peer", addr, "error", err)
+				s.queueReplica(addr, hashedKey, storageKey, tags, attrs)
+			}
+		}()
+	}
+	wg.Wait()
+	slog.Info("file stored", "key", storageKey, "replicas", len(owners))
+}
+
+// scheduleRebalance rebalances once the ring has not changed for
+// rebalanceDelay
+func (s *Server) scheduleRebalance() {
+	p := s.placement
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.timer = time.AfterFunc(rebalanceDelay, s.rebalance)
+}
+
+// rebalance copies the local files whose owners changed since they were
+// placed to their new owners. Each old owner that lost a key hands its copy
+// to one new owner and drops it; new owners left over are copied to by the
+// first old owner still on the ring, or by every holder when none is left.
+// Files stored on this node are copied but never dropped, and neither are
+// files with conflicting versions, which are resolved where they were found.
+func (s *Server) rebalance() {
+	p := s.placement
+	p.rebalancing.Lock()
+	defer p.rebalancing.Unlock()
+
+	p.mu.Lock()
+	ring := p.ring
+	held := maps.Clone(p.held)
+	addrs := maps.Clone(p.addrs)
+	p.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.quitch:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	factor := s.replicationFactor()
+	copied, dropped := 0, 0
+	for hashedKey, c := range held {
+		if c.ring == ring {
+			continue
+		}
+		was, is := c.ring.Owners(hashedKey, factor), ring.Owners(hashedKey, factor)
+		lost := slices.DeleteFunc(slices.Clone(was), func(id string) bool { return !ring.Has(id) || slices.Contains(is, id) })
+		gained := slices.DeleteFunc(slices.Clone(is), func(id string) bool { return slices.Contains(was, id) })
+		copier := s.ID
+		if i := slices.IndexFunc(was, ring.Has); i >= 0 {
+			copier = was[i]
+		}
+
+		pushed, failed := 0, false
+		for i, id := range gained {
+			from := copier
+			if i < len(lost) {
+				from = lost[i]
+			}
+			if from != s.ID || id == s.ID {
+				continue
+			}
+			if err := s.push(ctx, addrs[id], hashedKey, c.storageKey, nil, nil); err != nil {
+				slog.Warn("failed to move file to its new owner", "key", hashedKey, "node", id, "error", err)
+				failed = true
+				continue
+			}
+			pushed++
+		}
+		copied += pushed
+		if ctx.Err() != nil {
+			return
+		}
+		if failed {
+			// Retried at the next rebalance
+			continue
+		}
+
+		if pushed > 0 && slices.Contains(lost, s.ID) && c.storageKey == hashedKey && !s.versions.conflicted(hashedKey) {
+			if err := s.deleteStored(c.storageKey); err != nil {
+				slog.Warn("failed to drop moved file", "key", hashedKey, "error", err)
+				continue
+			}
+			s.forgetVersions(hashedKey)
+			p.release(hashedKey)
+			dropped++
+			continue
+		}
+		p.mu.Lock()
+		if cur, ok := p.held[hashedKey]; ok && cur.ring == c.ring {
+			p.held[hashedKey] = localCopy{storageKey: c.storageKey, ring: ring}
+		}
+		p.mu.Unlock()
+	}
+	slog.Info("rebalanced", "nodes", ring.Len(), "files", len(held), "copied", copied, "dropped", dropped)
+}
+
+// stopRebalancing cancels a scheduled rebalance
+func (p *placement) stopRebalancing() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+}
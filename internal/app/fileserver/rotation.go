@@ -0,0 +1,489 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/queue"
+	"github.com/Skpow1234/Peervault/internal/storage"
+)
+
+const (
+	// DefaultReencryptionRate is the number of bytes per second re-encrypted
+	// after a key rotation when Options.ReencryptionRate is zero
+	DefaultReencryptionRate = 8 << 20
+	// reencryptCheckpointEvery persists the progress of the re-encryption
+	// after this many objects
+	reencryptCheckpointEvery = 100
+	// reencryptRetryInterval is how long to wait before revisiting objects
+	// that were written while being re-encrypted
+	reencryptRetryInterval = time.Second
+)
+
+// ErrNoKeyManager is returned for key rotation on nodes encrypting with a
+// fixed key
+var ErrNoKeyManager = errors.New("the node has no key manager to rotate")
+
+// ReencryptionStatus is the state of the job re-encrypting stored objects
+// after a key rotation
+type ReencryptionStatus string
+
+const (
+	ReencryptionIdle      ReencryptionStatus = "idle"
+	ReencryptionRunning   ReencryptionStatus = "running"
+	ReencryptionCompleted ReencryptionStatus = "completed"
+)
+
+// ReencryptionProgress reports the job re-encrypting the stored objects
+// with the key of a generation
+type ReencryptionProgress struct {
+	Status     ReencryptionStatus `json:"status"`
+	Generation uint32             `json:"generation"`
+	// Total is the number of objects found when the job started or resumed
+	Total       int `json:"total"`
+	Reencrypted int `json:"reencrypted"`
+	// Current counts the objects already encrypted with the key of the
+	// generation, such as those written since the rotation
+	Current int `json:"current"`
+	Failed  int `json:"failed"`
+	// Bytes is the encrypted size of the objects re-encrypted so far
+	Bytes int64 `json:"bytes"`
+	// Cursor is the full path of the last object visited; a job interrupted
+	// by a restart resumes after it
+	Cursor      string    `json:"cursor,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+	StartedAt   time.Time `json:"started_at,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at,omitempty"`
+	CompletedAt time.Time `json:"completed_at,omitempty"`
+}
+
+// KeyRotationStatus is the key generation files are encrypted with and the
+// progress of moving the stored objects to it
+type KeyRotationStatus struct {
+	Generation uint32    `json:"generation"`
+	KeyID      string    `json:"key_id"`
+	RotatedAt  time.Time `json:"rotated_at"`
+	// NextRotation is zero unless rotation is scheduled
+	NextRotation time.Time            `json:"next_rotation,omitempty"`
+	Reencryption ReencryptionProgress `json:"reencryption"`
+}
+
+// rotationState is persisted under the storage root, as the objects can
+// only be read with the keys it names
+type rotationState struct {
+	Generation uint32               `json:"generation"`
+	RotatedAt  time.Time            `json:"rotated_at"`
+	Job        ReencryptionProgress `json:"job"`
+	// Retry lists the objects that were written while being re-encrypted
+	Retry []string `json:"retry,omitempty"`
+}
+
+// A rotation moves the key manager to its next generation, which new
+// writes use at once, and starts a job re-encrypting the stored objects in
+// the background at a bounded rate. Objects of every generation stay
+// readable throughout, as each names the generation of its key.
+type keyRotation struct {
+	keys     *crypto.KeyManager
+	store    *storage.Store
+	interval time.Duration
+	limiter  *rate.Limiter // nil when unlimited
+	// queue retries the objects the job fails on; nil leaves them failed
+	queue *queue.Queue
+
+	mu     sync.Mutex
+	state  rotationState
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newKeyRotation(keys *crypto.KeyManager, store *storage.Store, interval time.Duration, bytesPerSecond int64) *keyRotation {
+	r := &keyRotation{keys: keys, store: store, interval: interval}
+	if bytesPerSecond == 0 {
+		bytesPerSecond = DefaultReencryptionRate
+	}
+	if bytesPerSecond > 0 {
+		r.limiter = rate.NewLimiter(rate.Limit(bytesPerSecond), int(max(bytesPerSecond, transferChunkSize)))
+	}
+	return r
+}
+
+func (r *keyRotation) path() string {
+	return filepath.Join(r.store.Root, storage.KeysDirName, "rotation.json")
+}
+
+// start loads the persisted generation and resumes an interrupted job
+func (r *keyRotation) start() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, err := os.ReadFile(r.path())
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		r.state = rotationState{Generation: r.keys.Generation(), RotatedAt: r.keys.RotatedAt(), Job: ReencryptionProgress{Status: ReencryptionIdle}}
+	case err != nil:
+		return err
+	default:
+		var state rotationState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("corrupt key rotation state %s: %w", r.path(), err)
+		}
+		if err := r.keys.SetGeneration(state.Generation, state.RotatedAt); err != nil {
+			return err
+		}
+		r.state = state
+	}
+
+	if r.state.Job.Status == ReencryptionRunning {
+		slog.Info("resuming re-encryption", "generation", r.state.Job.Generation, "cursor", r.state.Job.Cursor)
+		r.startJobLocked()
+	}
+	return nil
+}
+
+// stop halts the job, leaving it to resume on the next start
+func (r *keyRotation) stop() {
+	r.mu.Lock()
+	cancel, done := r.cancel, r.done
+	r.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// rotatedAt returns when the key was last rotated
+func (r *keyRotation) rotatedAt() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state.RotatedAt
+}
+
+// rotate moves to the next key generation and restarts the job for it
+func (r *keyRotation) rotate() (KeyRotationStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.keys.RotateKey(); err != nil {
+		return KeyRotationStatus{}, err
+	}
+	if r.cancel != nil {
+		// The job of the previous generation stops after its current
+		// object; objects it did not reach are re-encrypted once
+		r.cancel()
+	}
+	r.state.Generation = r.keys.Generation()
+	r.state.RotatedAt = r.keys.RotatedAt()
+	r.state.Job = ReencryptionProgress{Status: ReencryptionRunning, Generation: r.state.Generation, StartedAt: time.Now()}
+	r.state.Retry = nil
+	if err := r.saveLocked(); err != nil {
+		return KeyRotationStatus{}, err
+	}
+	slog.Info("rotated encryption key", "generation", r.state.Generation, "key_id", r.keys.GetKeyID())
+	r.startJobLocked()
+	return r.statusLocked(), nil
+}
+
+func (r *keyRotation) status() KeyRotationStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.statusLocked()
+}
+
+func (r *keyRotation) statusLocked() KeyRotationStatus {
+	st := KeyRotationStatus{
+		Generation:   r.state.Generation,
+		KeyID:        r.keys.GetKeyID(),
+		RotatedAt:    r.state.RotatedAt,
+		Reencryption: r.state.Job,
+	}
+	if r.interval > 0 {
+		st.NextRotation = r.state.RotatedAt.Add(r.interval)
+	}
+	return st
+}
+
+// saveLocked persists the state; callers hold r.mu
+func (r *keyRotation) saveLocked() error {
+	r.state.Job.UpdatedAt = time.Now()
+	data, err := json.Marshal(r.state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path()), 0700); err != nil {
+		return err
+	}
+	tmp := r.path() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path())
+}
+
+func (r *keyRotation) startJobLocked() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	r.cancel, r.done = cancel, done
+	gen := r.state.Job.Generation
+
+	go func() {
+		defer close(done)
+		err := r.reencrypt(ctx, gen)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.state.Job.Generation != gen {
+			// Superseded by a later rotation
+			return
+		}
+		r.cancel, r.done = nil, nil
+		switch {
+		case ctx.Err() != nil:
+			// Stopped with the node; the job stays running to resume
+		case err != nil:
+			slog.Error("re-encryption stopped", "generation", gen, "error", err)
+			r.state.Job.LastError = err.Error()
+		default:
+			r.state.Job.Status = ReencryptionCompleted
+			r.state.Job.CompletedAt = time.Now()
+			slog.Info("re-encryption completed", "generation", gen,
+				"reencrypted", r.state.Job.Reencrypted, "failed", r.state.Job.Failed)
+		}
+		if err := r.saveLocked(); err != nil {
+			slog.Error("failed to save key rotation state", "error", err)
+		}
+	}()
+}
+
+// update applies fn to the progress of the job of generation gen, unless a
+// later rotation replaced it; it reports whether the job is still current
+func (r *keyRotation) update(gen uint32, fn func(*rotationState)) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state.Job.Generation != gen {
+		return false
+	}
+	fn(&r.state)
+	return true
+}
+
+// reencrypt visits the objects after the cursor in order, then retries
+// the ones that were written while being re-encrypted
+func (r *keyRotation) reencrypt(ctx context.Context, gen uint32) error {
+	paths, err := r.store.Objects()
+	if err != nil {
+		return err
+	}
+	var cursor string
+	if !r.update(gen, func(st *rotationState) {
+		st.Job.Total = len(paths)
+		cursor = st.Job.Cursor
+	}) {
+		return nil
+	}
+
+	for i, fullPath := range paths[sort.SearchStrings(paths, cursor):] {
+		if fullPath == cursor {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r.reencryptOne(ctx, gen, fullPath)
+		if err := ctx.Err(); err != nil {
+			// The object is visited again on resume
+			return err
+		}
+		if !r.update(gen, func(st *rotationState) { st.Job.Cursor = fullPath }) {
+			return nil
+		}
+		if (i+1)%reencryptCheckpointEvery == 0 {
+			r.mu.Lock()
+			err := r.saveLocked()
+			r.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		var retry []string
+		if !r.update(gen, func(st *rotationState) { retry, st.Retry = st.Retry, nil }) || len(retry) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			r.update(gen, func(st *rotationState) { st.Retry = append(st.Retry, retry...) })
+			return ctx.Err()
+		case <-time.After(reencryptRetryInterval):
+		}
+		for i, fullPath := range retry {
+			r.reencryptOne(ctx, gen, fullPath)
+			if err := ctx.Err(); err != nil {
+				r.update(gen, func(st *rotationState) { st.Retry = append(st.Retry, retry[i:]...) })
+				return err
+			}
+		}
+	}
+}
+
+// errCurrent marks objects already encrypted with the current key
+var errCurrent = errors.New("object is encrypted with the current key")
+
+func (r *keyRotation) reencryptOne(ctx context.Context, gen uint32, fullPath string) {
+	size, err := r.reencryptObject(ctx, fullPath)
+	retry := false
+	r.update(gen, func(st *rotationState) {
+		switch {
+		case err == nil:
+			st.Job.Reencrypted++
+			st.Job.Bytes += size
+		case errors.Is(err, errCurrent):
+			st.Job.Current++
+		case errors.Is(err, os.ErrNotExist):
+			// Deleted since the job listed it
+			st.Job.Total--
+		case errors.Is(err, storage.ErrObjectChanged):
+			st.Retry = append(st.Retry, fullPath)
+		case ctx.Err() != nil:
+			// Stopped mid-object
+		default:
+			st.Job.Failed++
+			st.Job.LastError = fmt.Sprintf("%s: %v", fullPath, err)
+			slog.Warn("failed to re-encrypt object", "path", fullPath, "error", err)
+			retry = r.queue != nil
+		}
+	})
+	if retry {
+		job := reencryptJob{Generation: gen, Path: fullPath}
+		if _, err := r.queue.Enqueue(reencryptJobKind, job, queue.EnqueueOptions{Key: fullPath, Priority: -1}); err != nil {
+			slog.Warn("failed to queue re-encryption", "path", fullPath, "error", err)
+		}
+	}
+}
+
+// reencryptObject re-encrypts an object with the current key, at the rate
+// limit, returning its size
+func (r *keyRotation) reencryptObject(ctx context.Context, fullPath string) (int64, error) {
+	size, err := r.checkObject(fullPath)
+	if err == nil {
+		err = r.wait(ctx, size)
+	}
+	if err == nil {
+		err = r.store.RewriteObject(fullPath, func(src io.Reader, dst io.Writer) error {
+			var plain bytes.Buffer
+			if _, _, err := r.keys.CopyDecrypt(src, &plain); err != nil {
+				return err
+			}
+			_, err := r.keys.CopyEncrypt(&plain, dst)
+			return err
+		})
+	}
+	return size, err
+}
+
+// reencryptJobKind is the kind of queued jobs retrying objects the
+// re-encryption failed on
+const reencryptJobKind = "reencrypt"
+
+// reencryptJob is the payload of re-encryption jobs
+type reencryptJob struct {
+	Generation uint32 `json:"generation"`
+	Path       string `json:"path"`
+}
+
+// retryObject re-encrypts an object the job of a generation failed on,
+// moving it from the failed objects of the job once it succeeds. Objects
+// of jobs a later rotation replaced are left to that job.
+func (r *keyRotation) retryObject(ctx context.Context, payload json.RawMessage) error {
+	var job reencryptJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return fmt.Errorf("%w: %v", queue.ErrPermanent, err)
+	}
+	if !r.update(job.Generation, func(*rotationState) {}) {
+		return nil
+	}
+	size, err := r.reencryptObject(ctx, job.Path)
+	if err != nil && !errors.Is(err, errCurrent) && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	r.update(job.Generation, func(st *rotationState) {
+		st.Job.Failed--
+		switch {
+		case err == nil:
+			st.Job.Reencrypted++
+			st.Job.Bytes += size
+		case errors.Is(err, errCurrent):
+			st.Job.Current++
+		default:
+			st.Job.Total--
+		}
+		if err := r.saveLocked(); err != nil {
+			slog.Error("failed to save key rotation state", "error", err)
+		}
+	})
+	return nil
+}
+
+// checkObject returns the size of an object, or errCurrent when its header
+// names the current generation
+func (r *keyRotation) checkObject(fullPath string) (int64, error) {
+	size, f, err := r.store.ReadObject(fullPath)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+	header := make([]byte, crypto.BlobHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, err
+	}
+	if r.keys.IsCurrent(header) {
+		return 0, errCurrent
+	}
+	return size, nil
+}
+
+// wait takes n bytes from the rate limit
+func (r *keyRotation) wait(ctx context.Context, n int64) error {
+	if r.limiter == nil {
+		return nil
+	}
+	for n > 0 {
+		take := min(n, int64(r.limiter.Burst()))
+		if err := r.limiter.WaitN(ctx, int(take)); err != nil {
+			return err
+		}
+		n -= take
+	}
+	return nil
+}
+
+// RotateKey moves the encryption key to its next generation. New writes
+// use it at once; stored objects are re-encrypted in the background.
+func (s *Server) RotateKey() (KeyRotationStatus, error) {
+	if s.rotation == nil {
+		return KeyRotationStatus{}, ErrNoKeyManager
+	}
+	return s.rotation.rotate()
+}
+
+// KeyRotation returns the current key generation and the progress of the
+// re-encryption of stored objects
+func (s *Server) KeyRotation() (KeyRotationStatus, error) {
+	if s.rotation == nil {
+		return KeyRotationStatus{}, ErrNoKeyManager
+	}
+	return s.rotation.status(), nil
+}
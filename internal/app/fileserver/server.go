@@ -3,19 +3,52 @@ package fileserver
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"encoding/binary"
-	"encoding/gob"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/Skpow1234/Peervault/internal/alerting"
+	"github.com/Skpow1234/Peervault/internal/analytics"
+	"github.com/Skpow1234/Peervault/internal/api/mqtt/topicacl"
+	"github.com/Skpow1234/Peervault/internal/cache"
+	"github.com/Skpow1234/Peervault/internal/codec"
+	"github.com/Skpow1234/Peervault/internal/crdt"
 	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/devices"
 	"github.com/Skpow1234/Peervault/internal/dto"
+	"github.com/Skpow1234/Peervault/internal/edge"
+	"github.com/Skpow1234/Peervault/internal/events"
+	"github.com/Skpow1234/Peervault/internal/export"
+	"github.com/Skpow1234/Peervault/internal/firmware"
+	"github.com/Skpow1234/Peervault/internal/geo"
+	"github.com/Skpow1234/Peervault/internal/hooks"
+	"github.com/Skpow1234/Peervault/internal/lwm2m"
+	"github.com/Skpow1234/Peervault/internal/messaging"
+	"github.com/Skpow1234/Peervault/internal/metadata"
 	"github.com/Skpow1234/Peervault/internal/peer"
+	"github.com/Skpow1234/Peervault/internal/policy"
+	"github.com/Skpow1234/Peervault/internal/pubsub"
+	"github.com/Skpow1234/Peervault/internal/queue"
+	"github.com/Skpow1234/Peervault/internal/retention"
+	"github.com/Skpow1234/Peervault/internal/scan"
+	"github.com/Skpow1234/Peervault/internal/scheduler"
+	"github.com/Skpow1234/Peervault/internal/search"
 	"github.com/Skpow1234/Peervault/internal/storage"
+	"github.com/Skpow1234/Peervault/internal/tasks"
+	"github.com/Skpow1234/Peervault/internal/telemetry"
 	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/acl"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/join"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/seeds"
+	"github.com/Skpow1234/Peervault/internal/watch"
+	"github.com/Skpow1234/Peervault/pkg/plugin"
 )
 
 type Options struct {
@@ -24,9 +57,148 @@ type Options struct {
 	KeyManager        *crypto.KeyManager
 	StorageRoot       string
 	PathTransformFunc storage.PathTransformFunc
-	Transport         netp2p.Transport
-	BootstrapNodes    []string
-	ResourceLimits    peer.ResourceLimits
+	// StorageHash names object paths when PathTransformFunc is nil, and
+	// hashes chunks
+	StorageHash    storage.HashAlgorithm
+	Transport      netp2p.Transport
+	BootstrapNodes []string
+	ResourceLimits peer.ResourceLimits
+	// Metadata optionally records file attributes in the metadata store
+	Metadata *metadata.Store
+	// Search optionally indexes the text of stored documents
+	Search *search.Index
+	// Retention optionally rejects deletes and overwrites of locked keys
+	Retention *retention.Manager
+	// LatencyProbeInterval is how often peers are probed for round trip
+	// times; zero uses peer.DefaultLatencyProbeInterval
+	LatencyProbeInterval time.Duration
+	// ReplicationFactor is the number of copies a file should have; zero
+	// uses DefaultReplicationFactor
+	ReplicationFactor int
+	// Capacity is the storage capacity in bytes, which weighs this node's
+	// share of keys on the hash ring until its free space is measured; zero
+	// counts as DefaultCapacity and measures the filesystem instead
+	Capacity int64
+	// HighWatermark is the fraction of the capacity in use above which the
+	// node refuses new files and replicas; zero uses DefaultHighWatermark
+	HighWatermark float64
+	// VirtualNodes is the number of ring positions of a node of average
+	// capacity; zero uses hashring.DefaultVirtualNodes
+	VirtualNodes int
+	// VersionsPath persists the version vectors of files and their
+	// conflicting versions; empty keeps them in memory
+	VersionsPath string
+	// Events receives file events such as conflicts; New creates a bus
+	// when nil
+	Events *events.Bus
+	// DocumentsPath persists the shared documents; empty keeps them in
+	// memory
+	DocumentsPath string
+	// DocumentGossipInterval is how often shared documents are compared
+	// with a random peer; zero uses DefaultDocumentGossipInterval
+	DocumentGossipInterval time.Duration
+	// Analytics optionally counts the reads, writes and deletes served by
+	// the node
+	Analytics *analytics.Collector
+	// Reports optionally runs scheduled reports on the access rollups,
+	// storing their output in the cluster
+	Reports *analytics.Reports
+	// Exports optionally dumps metadata, accesses and rollups as CSV or
+	// Parquet, on demand or on their schedules
+	Exports *export.Exporter
+	// Alerts optionally evaluates alert rules over the node's metrics
+	Alerts *alerting.Engine
+	// PeerACL optionally holds the rules deciding which peers may connect;
+	// the transport's handshake enforces them
+	PeerACL *acl.ACL
+	// JoinTokens optionally holds the join tokens new nodes present to
+	// join the cluster; the transport's handshake admits them
+	JoinTokens *join.Registry
+	// KeyRotationInterval is how often the encryption key is rotated; zero
+	// rotates only on request
+	KeyRotationInterval time.Duration
+	// ReencryptionRate bounds the bytes per second re-encrypted after a key
+	// rotation; zero uses DefaultReencryptionRate and a negative rate
+	// turns the bound off
+	ReencryptionRate int64
+	// Scanner optionally checks files stored on this node before they are
+	// written; copies replicated from peers are not scanned again
+	Scanner *scan.Pipeline
+	// Policy optionally evaluates operator rules on storing files and on
+	// copying them to peers
+	Policy *policy.Engine
+	// Hooks optionally runs WebAssembly hooks before files are stored,
+	// read and deleted and after they are stored; the events they emit
+	// are published on Events
+	Hooks *hooks.Engine
+	// Topics optionally keeps the messages of the topics it selects in
+	// logs stored in the cluster; see Server.Topics
+	Topics *pubsub.Config
+	// SwarmMinSize is the size from which files are fetched in pieces
+	// from every peer holding them at once; zero uses DefaultSwarmMinSize
+	// and a negative size fetches every file from one peer
+	SwarmMinSize int64
+	// SwarmPeerRate bounds the bytes per second a swarm download fetches
+	// from each peer; zero leaves it unbounded
+	SwarmPeerRate int64
+	// Seeds optionally list peers to connect to besides BootstrapNodes,
+	// such as DNS seeds or a rendezvous service
+	Seeds []seeds.Source
+	// SeedInterval is how often Seeds are listed; zero uses
+	// DefaultSeedInterval
+	SeedInterval time.Duration
+	// WatchHistory is how many changes are kept for watches resuming
+	// after a disconnect; zero uses watch.DefaultHistory
+	WatchHistory int
+	// ReadCache optionally keeps the decrypted content of hot local files
+	// in memory; writes and deletes on this node drop what it holds of
+	// them
+	ReadCache *cache.ByteCache[[]byte]
+	// Edge optionally makes the node a pull-through cache of an upstream
+	// cluster: files neither the node nor its peers have are fetched from
+	// there and kept, then checked against it once their TTL runs out
+	Edge *edge.PullThrough
+	// Region, Location and PublicURL tell peers where this node is and how
+	// clients reach it, so clients are sent to the node nearest to them
+	Region    string
+	Location  *geo.Point
+	PublicURL string
+	// Devices optionally issues the client certificates IoT devices
+	// enroll for over EST and present to the MQTT and CoAP APIs
+	Devices *devices.CA
+	// TopicACL optionally holds the rules deciding which MQTT topics
+	// clients may publish to and subscribe to; the MQTT broker enforces
+	// them
+	TopicACL *topicacl.ACL
+	// LwM2M optionally manages the devices registering over the CoAP
+	// API's LwM2M registration interface
+	LwM2M *lwm2m.Server
+	// Telemetry optionally aggregates the numeric readings devices report,
+	// those LwM2M devices notify for their observed paths included
+	Telemetry *telemetry.Collector
+	// Firmware optionally distributes firmware images, stored as objects
+	// of the node, to IoT devices in staged rollouts
+	Firmware *firmware.Manager
+	// Messaging optionally carries the messages applications send to
+	// channels of other nodes through the peer network, queueing them
+	// while those nodes are offline
+	Messaging *messaging.Mailbox
+	// Tasks optionally runs the node's task handlers for the peers it
+	// allows, and calls those of the peers
+	Tasks *tasks.Host
+	// Scheduler runs the periodic work of the node, such as reports, key
+	// rotation and edge garbage collection; other subsystems may register
+	// their own jobs. Nil uses a scheduler keeping its state in memory.
+	Scheduler *scheduler.Scheduler
+	// Queue runs the background work of the node that must survive
+	// restarts, such as replicas peers missed, objects re-encryption failed
+	// on, thumbnails and webhooks, retrying it until it succeeds. Nil uses
+	// a queue keeping its jobs in memory.
+	Queue *queue.Queue
+	// EventSinks optionally receive the changes to files made through this
+	// node and the events of Events, by the name of the plugin providing
+	// them
+	EventSinks map[string]plugin.EventSink
 }
 
 type Server struct {
@@ -39,18 +211,24 @@ type Server struct {
 	healthManager   *peer.HealthManager
 	resourceManager *peer.ResourceManager
 	fileOpManager   *FileOperationManager
-}
-
-// getEncryptionKey returns the current encryption key, preferring KeyManager over the legacy EncKey
-func (s *Server) getEncryptionKey() []byte {
-	if s.KeyManager != nil {
-		return s.KeyManager.GetEncryptionKey()
-	}
-	return s.EncKey
+	latency         *peer.LatencyProber
+	replicas        *replicaTracker
+	placement       *placement
+	transfers       *transfers
+	swarm           *swarms
+	discovered      *discoveredPeers
+	versions        *versionTable
+	documents       *crdt.Store
+	metrics         *metricHistory
+	decommission    *decommission
+	space           *spaceMonitor
+	changes         *watch.Journal
+	rotation        *keyRotation   // nil without a key manager
+	topics          *pubsub.Topics // nil without durable topics
 }
 
 func New(opts Options) *Server {
-	storeOpts := storage.StoreOpts{Root: opts.StorageRoot, PathTransformFunc: opts.PathTransformFunc}
+	storeOpts := storage.StoreOpts{Root: opts.StorageRoot, PathTransformFunc: opts.PathTransformFunc, Hash: opts.StorageHash}
 	if len(opts.ID) == 0 {
 		opts.ID = crypto.GenerateID()
 	}
@@ -72,6 +250,15 @@ func New(opts Options) *Server {
 	if opts.ResourceLimits.MaxConcurrentStreams == 0 {
 		opts.ResourceLimits = peer.DefaultResourceLimits()
 	}
+	if opts.Events == nil {
+		opts.Events = events.NewBus()
+	}
+	if opts.Scheduler == nil {
+		opts.Scheduler = scheduler.New(scheduler.Options{})
+	}
+	if opts.Queue == nil {
+		opts.Queue = queue.New(queue.Options{})
+	}
 
 	server := &Server{
 		Options:    opts,
@@ -79,7 +266,17 @@ func New(opts Options) *Server {
 		store:      storage.NewStore(storeOpts),
 		quitch:     make(chan struct{}),
 		peers:      make(map[string]netp2p.Peer),
+		replicas:   newReplicaTracker(),
+		transfers:  newTransfers(),
+		swarm:      newSwarms(),
+		discovered: newDiscoveredPeers(),
+		versions:   newVersionTable(opts.VersionsPath),
+		documents:  crdt.NewStore(opts.ID, opts.DocumentsPath),
+		metrics:    newMetricHistory(),
 	}
+	server.decommission = newDecommission()
+	server.space = &spaceMonitor{}
+	server.changes = watch.New(opts.WatchHistory)
 
 	// Initialize health manager
 	server.initializeHealthManager()
@@ -90,6 +287,26 @@ func New(opts Options) *Server {
 	// Initialize file operation manager
 	server.fileOpManager = NewFileOperationManager(20) // Allow up to 20 concurrent file operations
 
+	// Initialize latency probing
+	server.initializeLatencyProber()
+
+	// Place this node on the hash ring
+	server.initializePlacement()
+
+	if keyManager != nil {
+		server.rotation = newKeyRotation(keyManager, server.store, opts.KeyRotationInterval, opts.ReencryptionRate)
+		server.rotation.queue = opts.Queue
+	}
+	if opts.Topics != nil {
+		server.topics = pubsub.New(*opts.Topics, objectStore{server: server})
+	}
+	if opts.Firmware != nil {
+		opts.Firmware.Attach(objectStore{server: server})
+	}
+	if opts.LwM2M != nil && opts.Telemetry != nil {
+		opts.LwM2M.Watch(server.recordObservation)
+	}
+
 	return server
 }
 
@@ -108,6 +325,22 @@ func (s *Server) initializeHealthManager() {
 	s.healthManager = peer.NewHealthManager(opts)
 }
 
+// initializeLatencyProber sets up round trip time measurement between peers
+func (s *Server) initializeLatencyProber() {
+	opts := peer.LatencyProberOpts{
+		ID:       s.ID,
+		Interval: s.LatencyProbeInterval,
+		Peers:    s.peerAddrs,
+		Send: func(addr string, probe dto.LatencyProbe) error {
+			return s.send(addr, &Message{Payload: probe})
+		},
+	}
+	if s.Transport != nil {
+		opts.Addr = s.Transport.Addr()
+	}
+	s.latency = peer.NewLatencyProber(opts)
+}
+
 // handlePeerDisconnect is called when a peer is disconnected
 func (s *Server) handlePeerDisconnect(address string) {
 	s.peerLock.Lock()
@@ -122,6 +355,9 @@ func (s *Server) handlePeerDisconnect(address string) {
 	if s.resourceManager != nil {
 		s.resourceManager.RemovePeer(address)
 	}
+
+	s.transfers.dropPeer(address)
+	s.removeFromRing(address)
 }
 
 // handlePeerReconnect is called when a peer reconnects
@@ -142,91 +378,399 @@ func (s *Server) dialPeer(address string) (netp2p.Peer, error) {
 
 type Message struct{ Payload any }
 
-func (s *Server) broadcast(msg *Message) error {
-	buf := new(bytes.Buffer)
-	if err := gob.NewEncoder(buf).Encode(msg); err != nil {
+// send delivers a message to the peer at addr
+func (s *Server) send(addr string, msg *Message) error {
+	p, ok := s.getPeer(addr)
+	if !ok {
+		return fmt.Errorf("peer %s not in map", addr)
+	}
+	data, err := encodeMessage(p, msg)
+	if err != nil {
 		return err
 	}
+	return netp2p.NewFrameWriter(p).WriteMessage(data)
+}
 
-	payload := buf.Bytes()
+// encodeMessage encodes msg in the codec negotiated with peer
+func encodeMessage(peer netp2p.Peer, msg *Message) ([]byte, error) {
+	c, ok := codec.ByID(netp2p.PeerCodec(peer))
+	if !ok {
+		c = codec.Gob
+	}
+	return c.Encode(msg.Payload)
+}
 
-	// Get healthy peers from health manager if available
-	var peers []netp2p.Peer
-	if s.healthManager != nil {
-		peers = s.healthManager.GetHealthyPeers()
-	} else {
-		// Fallback to all peers if health manager is not available
-		s.peerLock.RLock()
-		peers = make([]netp2p.Peer, 0, len(s.peers))
-		for _, peer := range s.peers {
-			peers = append(peers, peer)
-		}
-		s.peerLock.RUnlock()
-	}
-
-	// Send to healthy peers only
-	for _, p := range peers {
-		frameWriter := netp2p.NewFrameWriter(p)
-		if err := frameWriter.WriteMessage(payload); err != nil {
-			slog.Warn("failed to send message to peer", "peer", p.RemoteAddr(), "error", err)
-			// Update peer health status
-			if s.healthManager != nil {
-				s.healthManager.UpdatePeerHealth(p.RemoteAddr().String(), peer.StatusUnhealthy)
+// decodeMessage decodes the message of rpc in the codec it names
+func decodeMessage(rpc netp2p.RPC) (*Message, error) {
+	c, ok := codec.ByID(rpc.Codec)
+	if !ok {
+		c = codec.Gob
+	}
+	payload, err := c.Decode(rpc.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Payload: payload}, nil
+}
+
+// peerAddrs returns the addresses of the connected peers
+func (s *Server) peerAddrs() []string {
+	s.peerLock.RLock()
+	defer s.peerLock.RUnlock()
+	addrs := make([]string, 0, len(s.peers))
+	for addr := range s.peers {
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// PeerInfo describes a connected peer
+type PeerInfo struct {
+	// ID is the node ID the peer authenticated as; peers with an identity
+	// key are known by the key-derived ID, which survives their restarts
+	ID string
+	// Addr is the address of the connection
+	Addr string
+	// PublicKey is the peer's identity key, nil when it presented none
+	PublicKey ed25519.PublicKey
+	// Outbound reports whether this node dialed the connection
+	Outbound bool
+	// ProtocolVersion is the protocol version negotiated with the peer;
+	// peers on older versions show which nodes still await an upgrade
+	ProtocolVersion uint8
+	// Free is the free space the peer advertises, zero when it does not
+	Free int64
+	// Full peers are above their high watermark and refuse new copies
+	Full bool
+}
+
+// Peers returns the connected peers, by ID
+func (s *Server) Peers() []PeerInfo {
+	s.peerLock.RLock()
+	peers := make([]PeerInfo, 0, len(s.peers))
+	for addr, p := range s.peers {
+		info := PeerInfo{ID: addr, Addr: addr}
+		if tcp, ok := p.(*netp2p.TCPPeer); ok {
+			if id := tcp.NodeID(); id != "" {
+				info.ID = id
 			}
+			info.PublicKey = tcp.PublicKey()
+			info.Outbound = tcp.Outbound()
+			info.ProtocolVersion = tcp.ProtocolVersion()
 		}
+		peers = append(peers, info)
 	}
-	return nil
+	s.peerLock.RUnlock()
+	s.placement.mu.Lock()
+	for i, info := range peers {
+		if node, ok := s.placement.nodes[info.ID]; ok {
+			peers[i].Free, peers[i].Full = node.Free, node.Full
+		}
+	}
+	s.placement.mu.Unlock()
+	slices.SortFunc(peers, func(a, b PeerInfo) int { return strings.Compare(a.ID, b.ID) })
+	return peers
 }
 
+// Disconnect closes the connection to the peer with the given ID or
+// address, reporting whether it was connected
+func (s *Server) Disconnect(id string) bool {
+	for _, p := range s.Peers() {
+		if p.ID != id && p.Addr != id {
+			continue
+		}
+		if conn, ok := s.getPeer(p.Addr); ok {
+			_ = conn.Close()
+			s.handlePeerDisconnect(p.Addr)
+			return true
+		}
+	}
+	return false
+}
+
+// EnforcePeerACL disconnects the connected peers the peer ACL no longer
+// lets in, returning them
+func (s *Server) EnforcePeerACL() []PeerInfo {
+	if s.PeerACL == nil {
+		return nil
+	}
+	var dropped []PeerInfo
+	for _, p := range s.Peers() {
+		if s.PeerACL.Check(acl.Peer{Addr: p.Addr, NodeID: p.ID, Verified: p.PublicKey != nil}) == nil {
+			continue
+		}
+		if s.Disconnect(p.Addr) {
+			slog.Warn("disconnected peer denied by the peer ACL", "peer", p.ID, "addr", p.Addr)
+			dropped = append(dropped, p)
+		}
+	}
+	return dropped
+}
+
+// Latency returns the round trip times to peers, for placement decisions
+func (s *Server) Latency() *peer.LatencyProber { return s.latency }
+
+// Topology returns the latency map of this node's neighbourhood
+func (s *Server) Topology() peer.Topology { return s.latency.Topology() }
+
+// localKey returns the key the local copy of a file is stored under: the
+// plain key for files stored on this node, the hashed key for copies
+// received from peers
+func (s *Server) localKey(key string) (string, bool) {
+	for _, storageKey := range []string{key, crypto.HashKey(key)} {
+		if s.store.Has(storageKey) {
+			return storageKey, true
+		}
+	}
+	return "", false
+}
+
+// Get returns the content of a file. A local copy is served directly;
+// large files are fetched from every peer holding them at once; otherwise
+// the file is fetched from the peers that own it, then from the other
+// peers, nearest first. Edge nodes finally pull it from their origin.
 func (s *Server) Get(ctx context.Context, key string) (io.Reader, error) {
-	if s.store.Has(key) {
+	if err := s.runFileHooks(ctx, hooks.PreGet, key); err != nil {
+		return nil, err
+	}
+	if storageKey, ok := s.localKey(key); ok {
+		if r, handled, err := s.revalidatePulled(ctx, key); handled {
+			return r, err
+		}
 		slog.Info("serving file", "key", key, "addr", s.Transport.Addr())
-		// Read encrypted data from disk and decrypt it
-		_, encryptedReader, err := s.store.Read(key)
+		data, err := s.readDecrypted(storageKey)
 		if err != nil {
 			return nil, err
 		}
+		s.recordAccess(analytics.OpRead, key, "", int64(len(data)))
+		return bytes.NewReader(data), nil
+	}
 
-		// Create a buffer to hold the decrypted data
-		var decryptedBuffer bytes.Buffer
-		_, err = crypto.CopyDecrypt(s.getEncryptionKey(), encryptedReader, &decryptedBuffer)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decrypt file: %w", err)
+	slog.Info("dont have file", "key", key, "addr", s.Transport.Addr())
+	hashedKey := crypto.HashKey(key)
+	data, swarmed, err := s.swarmFetch(ctx, hashedKey)
+	switch {
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	case err != nil:
+		slog.Warn("swarm download failed, fetching from one peer", "key", key, "error", err)
+	case swarmed:
+		s.recordAccess(analytics.OpRead, key, "", int64(len(data)))
+		return bytes.NewReader(data), nil
+	}
+	for _, addr := range s.fetchOrder(hashedKey) {
+		data, err := s.fetch(ctx, addr, hashedKey)
+		if err == nil {
+			s.recordAccess(analytics.OpRead, key, "", int64(len(data)))
+			return bytes.NewReader(data), nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !errors.Is(err, errNotHeld) {
+			slog.Warn("failed to fetch file", "key", key, "peer", addr, "error", err)
 		}
-
-		return bytes.NewReader(decryptedBuffer.Bytes()), nil
 	}
-	slog.Info("dont have file", "key", key, "addr", s.Transport.Addr())
-	msg := Message{Payload: dto.GetFile{ID: s.ID, Key: crypto.HashKey(key)}}
-	if err := s.broadcast(&msg); err != nil {
-		return nil, err
+	if s.Edge != nil && s.Edge.Pulls(key) {
+		return s.pull(ctx, key)
 	}
-
-	// Wait for acknowledgments from peers
-	// For now, since replication is disabled, we'll just return an error
-	// indicating that the file is not available on other peers
 	return nil, fmt.Errorf("file not found on any peer")
 }
 
 func (s *Server) Store(ctx context.Context, key string, r io.Reader) error {
-	// Store the file locally with encryption at rest
-	size, err := s.store.WriteDecrypt(crypto.CopyEncrypt, s.getEncryptionKey(), key, r)
+	return s.StoreWithAttributes(ctx, key, r, nil, nil)
+}
+
+// StoreWithAttributes stores a file together with user-defined tags and
+// metadata, which are announced to peers alongside the file
+func (s *Server) StoreWithAttributes(ctx context.Context, key string, r io.Reader, tags []string, attrs map[string]string) error {
+	_, err := s.StoreScanned(ctx, key, r, tags, attrs)
+	return err
+}
+
+// StoreScanned stores a file like StoreWithAttributes and returns the report
+// of the Scanner, nil without one. Pre-store hooks run first and may change
+// the metadata; files they reject fail with hooks.ErrRejected. Files the
+// Policy denies fail with policy.ErrDenied. A rejected file is not stored and a quarantined one is
+// stored under the quarantine key; both fail with the error of the report.
+// The outcome of the scan is recorded in the metadata.
+func (s *Server) StoreScanned(ctx context.Context, key string, r io.Reader, tags []string, attrs map[string]string) (*scan.Report, error) {
+	if err := metadata.ValidateAttributes(tags, attrs); err != nil {
+		return nil, err
+	}
+	preStore := s.Hooks != nil && s.Hooks.Has(hooks.PreStore)
+	if s.Scanner == nil && s.Policy == nil && !preStore {
+		return nil, s.storeFile(ctx, key, r, tags, attrs)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if preStore {
+		in := hooks.Input{Key: key, Size: int64(len(data)), Tags: tags, Metadata: attrs}
+		if attrs, err = s.runHooks(ctx, hooks.PreStore, in); err != nil {
+			return nil, err
+		}
+		if err := metadata.ValidateAttributes(tags, attrs); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.checkStore(key, data, tags, attrs); err != nil {
+		return nil, err
+	}
+	if s.Scanner == nil {
+		return nil, s.storeFile(ctx, key, bytes.NewReader(data), tags, attrs)
+	}
+	report, err := s.Scanner.Scan(ctx, scan.Object{Key: key, Data: data})
 	if err != nil {
+		return nil, err
+	}
+	tags, attrs = report.Apply(tags, attrs)
+	switch report.Action {
+	case scan.ActionReject:
+		return report, report.Err()
+	case scan.ActionQuarantine:
+		attrs[scan.MetaOriginalKey] = key
+		if err := s.storeFile(ctx, report.Key, bytes.NewReader(data), tags, attrs); err != nil {
+			return report, err
+		}
+		return report, report.Err()
+	}
+	return report, s.storeFile(ctx, key, bytes.NewReader(data), tags, attrs)
+}
+
+func (s *Server) storeFile(ctx context.Context, key string, r io.Reader, tags []string, attrs map[string]string) error {
+	if err := metadata.ValidateAttributes(tags, attrs); err != nil {
 		return err
 	}
+	if s.Retention != nil && s.store.Has(key) {
+		if err := s.Retention.Check(ctx, key, retention.OpOverwrite, s.ID); err != nil {
+			return err
+		}
+	}
+	if err := s.checkSpace(key); err != nil {
+		return err
+	}
+	op := watch.Created
+	if s.store.Has(key) {
+		op = watch.Updated
+	}
 
-	// Broadcast the store message to peers
-	msg := Message{Payload: dto.StoreFile{ID: s.ID, Key: crypto.HashKey(key), Size: size}}
-	if err := s.broadcast(&msg); err != nil {
+	// Capture indexable documents while they are written so the plaintext
+	// does not have to be read back and decrypted
+	var capture *cappedBuffer
+	if s.Search != nil && search.DetectFormat(key, "") != "" {
+		capture = &cappedBuffer{limit: search.MaxDocumentSize}
+		r = io.TeeReader(r, capture)
+	}
+
+	// Store the file locally with encryption at rest. A file written here
+	// is no longer a copy of the origin's.
+	s.forgetPulled(key)
+	plain := &countingReader{r: r}
+	size, err := s.writeEncrypted(key, plain)
+	if err != nil {
 		return err
 	}
+	s.consumeSpace(size)
+
+	if capture != nil {
+		if err := s.Search.IndexContent(key, key, "", bytes.NewReader(capture.Bytes())); err != nil {
+			slog.Warn("failed to index document", "key", key, "error", err)
+		}
+	}
 
-	// For now, skip streaming replication to avoid frame size issues
-	// TODO: Implement proper file replication
-	slog.Info("file stored locally, replication disabled", "key", key, "size", size)
+	hashedKey := crypto.HashKey(key)
+	s.recordAttributes(metadata.FileRecord{Key: key, HashedKey: hashedKey, Size: size, Tags: tags, Metadata: attrs})
+	s.wroteVersion(key, hashedKey, size)
+	s.placement.hold(hashedKey, key)
+	s.recordAccess(analytics.OpWrite, key, "", plain.n)
+	s.changes.Record(op, key, plain.n)
+
+	// Copy the file to the peers that own it
+	s.replicate(ctx, hashedKey, key, tags, attrs)
+	if _, err := s.runHooks(ctx, hooks.PostStore, hooks.Input{Key: key, Size: size, Tags: tags, Metadata: attrs}); err != nil {
+		slog.Warn("post-store hooks failed", "key", key, "error", err)
+	}
 	return nil
 }
 
+// Delete removes a file from the local store, its metadata and the search
+// index. Keys under retention or legal hold are refused, as are deletions
+// on-delete hooks reject.
+func (s *Server) Delete(ctx context.Context, key string) error {
+	if s.Retention != nil {
+		if err := s.Retention.Check(ctx, key, retention.OpDelete, s.ID); err != nil {
+			return err
+		}
+	}
+	if err := s.runFileHooks(ctx, hooks.OnDelete, key); err != nil {
+		return err
+	}
+
+	if err := s.deleteStored(key); err != nil {
+		return err
+	}
+	// Counted before the metadata record that names the tenant goes
+	s.recordAccess(analytics.OpDelete, key, "", 0)
+	if s.Metadata != nil && s.Metadata.Role() == metadata.RolePrimary {
+		if _, err := s.Metadata.Delete(key); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+			slog.Warn("failed to delete file record", "key", key, "error", err)
+		}
+	}
+	if s.Search != nil {
+		s.Search.Remove(key)
+	}
+	s.replicas.forget(key)
+	s.forgetPulled(key)
+	s.forgetVersions(crypto.HashKey(key))
+	s.placement.release(crypto.HashKey(key))
+	s.changes.Record(watch.Deleted, key, 0)
+	return nil
+}
+
+// recordAttributes writes a file record to the metadata store when this node owns it
+func (s *Server) recordAttributes(rec metadata.FileRecord) {
+	if s.Metadata == nil || s.Metadata.Role() != metadata.RolePrimary {
+		return
+	}
+	if existing, err := s.Metadata.Get(rec.Key); err == nil {
+		rec.CreatedAt = existing.CreatedAt
+	}
+	if _, err := s.Metadata.Put(rec); err != nil {
+		slog.Warn("failed to record file attributes", "key", rec.Key, "error", err)
+	}
+}
+
+// cappedBuffer keeps the first limit bytes written to it and discards the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Storage returns the local object store
+func (s *Server) Storage() *storage.Store { return s.store }
+
 func (s *Server) Stop() {
 	// Stop health manager
 	if s.healthManager != nil {
@@ -238,6 +782,38 @@ func (s *Server) Stop() {
 		s.resourceManager.Shutdown()
 	}
 
+	s.latency.Stop()
+	s.placement.stopRebalancing()
+	s.decommission.stop()
+	s.Scheduler.Stop()
+	if err := s.Scheduler.Flush(); err != nil {
+		slog.Warn("failed to persist job schedules", "error", err)
+	}
+	if err := s.Queue.Stop(); err != nil {
+		slog.Warn("failed to persist the job queue", "error", err)
+	}
+	if s.rotation != nil {
+		s.rotation.stop()
+	}
+	if s.topics != nil {
+		s.topics.Stop()
+	}
+	if s.Analytics != nil {
+		if err := s.Analytics.Flush(); err != nil {
+			slog.Warn("failed to persist access analytics", "error", err)
+		}
+	}
+	if s.Telemetry != nil {
+		if err := s.Telemetry.Flush(); err != nil {
+			slog.Warn("failed to persist telemetry", "error", err)
+		}
+	}
+	if s.Messaging != nil {
+		if err := s.Messaging.Flush(); err != nil {
+			slog.Warn("failed to persist application messages", "error", err)
+		}
+	}
+
 	// Close the quit channel to stop the main loop
 	select {
 	case <-s.quitch:
@@ -251,8 +827,10 @@ func (s *Server) Stop() {
 }
 
 func (s *Server) OnPeer(p netp2p.Peer) error {
+	if s.decommission.phase() == DecommissionCompleted {
+		return errDecommissioned
+	}
 	s.peerLock.Lock()
-	defer s.peerLock.Unlock()
 	s.peers[p.RemoteAddr().String()] = p
 
 	// Add peer to health monitoring
@@ -265,8 +843,10 @@ func (s *Server) OnPeer(p netp2p.Peer) error {
 		s.resourceManager.AddPeer(p.RemoteAddr().String())
 	}
 
+	s.peerLock.Unlock()
+
 	slog.Info("connected", "peer", p.RemoteAddr())
-	return nil
+	return s.announce(p)
 }
 
 // OnStream handles incoming file streams
@@ -288,7 +868,7 @@ func (s *Server) OnStream(peer netp2p.Peer, reader io.Reader) error {
 		slog.String("peer", peer.RemoteAddr().String()))
 
 	// Store the file with encryption
-	size, err := s.store.WriteDecrypt(crypto.CopyEncrypt, s.getEncryptionKey(), key, reader)
+	size, err := s.writeEncrypted(key, reader)
 	if err != nil {
 		return fmt.Errorf("failed to store streamed file: %w", err)
 	}
@@ -311,12 +891,12 @@ func (s *Server) loop() {
 	for {
 		select {
 		case rpc := <-s.Transport.Consume():
-			var msg Message
-			if err := gob.NewDecoder(bytes.NewReader(rpc.Payload)).Decode(&msg); err != nil {
+			msg, err := decodeMessage(rpc)
+			if err != nil {
 				slog.Error("decoding error", "err", err)
 				continue
 			}
-			if err := s.handleMessage(rpc.From, &msg); err != nil {
+			if err := s.handleMessage(rpc.From, msg); err != nil {
 				slog.Error("handle message error", "err", err)
 			}
 		case <-s.quitch:
@@ -331,6 +911,44 @@ func (s *Server) handleMessage(from string, msg *Message) error {
 		return s.handleMessageStoreFile(from, v)
 	case dto.GetFile:
 		return s.handleMessageGetFile(from, v)
+	case dto.LatencyProbe:
+		return s.send(from, &Message{Payload: s.latency.HandleProbe(from, v)})
+	case dto.LatencyReply:
+		s.latency.HandleReply(from, v)
+	case dto.HasFile:
+		return s.handleMessageHasFile(from, v)
+	case dto.HasFileAck:
+		s.replicas.deliver(from, v)
+	case dto.NodeInfo:
+		s.handleMessageNodeInfo(from, v)
+	case dto.StoreChunk:
+		return s.handleMessageStoreChunk(from, v)
+	case dto.StoreFileAck:
+		s.handleMessageStoreFileAck(v)
+	case dto.FetchFile:
+		return s.handleMessageFetchFile(from, v)
+	case dto.FetchChunk:
+		s.handleMessageFetchChunk(v)
+	case dto.DocumentDigest:
+		return s.handleMessageDocumentDigest(from, v)
+	case dto.DocumentState:
+		s.handleMessageDocumentState(from, v)
+	case dto.PieceMap:
+		return s.handleMessagePieceMap(from, v)
+	case dto.PieceMapAck:
+		s.swarm.deliver(from, v)
+	case dto.FetchPiece:
+		return s.handleMessageFetchPiece(from, v)
+	case dto.AppMessage:
+		return s.handleMessageAppMessage(from, v)
+	case dto.AppReceipt:
+		s.handleMessageAppReceipt(v)
+	case dto.TaskCall:
+		return s.handleMessageTaskCall(from, v)
+	case dto.TaskData:
+		s.handleMessageTaskData(from, v)
+	case dto.FileAttributes:
+		s.handleMessageFileAttributes(from, v)
 	}
 	return nil
 }
@@ -344,6 +962,10 @@ func (s *Server) getPeer(from string) (netp2p.Peer, bool) {
 }
 
 func (s *Server) handleMessageGetFile(from string, msg dto.GetFile) error {
+	// The file goes back as a raw stream, which sealed links do not carry
+	if peer, ok := s.getPeer(from); ok && netp2p.Sealed(peer) {
+		return fmt.Errorf("peer %s requested a raw stream over a sealed link", from)
+	}
 	// Check if we have the file
 	hasFile := s.store.Has(msg.Key)
 	var fileSize int64
@@ -366,17 +988,14 @@ func (s *Server) handleMessageGetFile(from string, msg dto.GetFile) error {
 		FileSize:  fileSize,
 	}
 
-	ackMsg := Message{Payload: ack}
-	buf := new(bytes.Buffer)
-	if err := gob.NewEncoder(buf).Encode(ackMsg); err != nil {
-		return err
-	}
-
 	// Send acknowledgment to the requesting peer
 	peer, ok := s.getPeer(from)
 	if ok {
-		frameWriter := netp2p.NewFrameWriter(peer)
-		if err := frameWriter.WriteMessage(buf.Bytes()); err != nil {
+		data, err := encodeMessage(peer, &Message{Payload: ack})
+		if err != nil {
+			return err
+		}
+		if err := netp2p.NewFrameWriter(peer).WriteMessage(data); err != nil {
 			return err
 		}
 	}
@@ -417,6 +1036,10 @@ func (s *Server) handleMessageGetFile(from string, msg dto.GetFile) error {
 }
 
 func (s *Server) handleMessageStoreFile(from string, msg dto.StoreFile) error {
+	// The file follows as raw bytes, which sealed links do not carry
+	if peer, ok := s.getPeer(from); ok && netp2p.Sealed(peer) {
+		return fmt.Errorf("peer %s offered a raw stream over a sealed link", from)
+	}
 	// Send acknowledgment immediately
 	ack := dto.StoreFileAck{
 		RequestID: msg.ID, // Use the request ID
@@ -425,17 +1048,14 @@ func (s *Server) handleMessageStoreFile(from string, msg dto.StoreFile) error {
 		Error:     "",
 	}
 
-	ackMsg := Message{Payload: ack}
-	buf := new(bytes.Buffer)
-	if err := gob.NewEncoder(buf).Encode(ackMsg); err != nil {
-		return err
-	}
-
 	// Send acknowledgment to the requesting peer
 	peer, ok := s.getPeer(from)
 	if ok {
-		frameWriter := netp2p.NewFrameWriter(peer)
-		if err := frameWriter.WriteMessage(buf.Bytes()); err != nil {
+		data, err := encodeMessage(peer, &Message{Payload: ack})
+		if err != nil {
+			return err
+		}
+		if err := netp2p.NewFrameWriter(peer).WriteMessage(data); err != nil {
 			return err
 		}
 	}
@@ -445,10 +1065,15 @@ func (s *Server) handleMessageStoreFile(from string, msg dto.StoreFile) error {
 	if !ok {
 		return fmt.Errorf("peer (%s) could not be found in the peer list", from)
 	}
-	n, err := s.store.WriteDecrypt(crypto.CopyEncrypt, s.getEncryptionKey(), msg.Key, io.LimitReader(peer, msg.Size))
+	n, err := s.writeEncrypted(msg.Key, io.LimitReader(peer, msg.Size))
 	if err != nil {
 		return err
 	}
+	// Attributes are recorded under the user's key, which older peers do
+	// not send
+	if msg.UserKey != "" && crypto.HashKey(msg.UserKey) == msg.Key && (len(msg.Tags) > 0 || len(msg.Metadata) > 0) {
+		s.recordAttributes(metadata.FileRecord{Key: msg.UserKey, HashedKey: msg.Key, Size: n, Tags: msg.Tags, Metadata: msg.Metadata})
+	}
 	slog.Info("written", "bytes", n, "addr", s.Transport.Addr())
 	return nil
 }
@@ -471,6 +1096,68 @@ func (s *Server) BootstrapNetwork() error {
 func (s *Server) Start() error {
 	slog.Info("starting fileserver", "addr", s.Transport.Addr())
 
+	if _, err := s.UpgradeLayout(false); err != nil {
+		return err
+	}
+	if err := s.versions.load(); err != nil {
+		return err
+	}
+	if err := s.documents.Load(); err != nil {
+		return err
+	}
+	if s.rotation != nil {
+		if err := s.rotation.start(); err != nil {
+			return fmt.Errorf("failed to load key rotation state: %w", err)
+		}
+	}
+	if s.Analytics != nil {
+		if err := s.Analytics.Load(); err != nil {
+			return err
+		}
+		go s.flushAnalytics()
+	}
+	if s.Telemetry != nil {
+		if err := s.Telemetry.Load(); err != nil {
+			return err
+		}
+		go s.flushTelemetry()
+	}
+	if s.Messaging != nil {
+		if err := s.Messaging.Load(); err != nil {
+			return err
+		}
+		s.Messaging.Attach(s.ID, messenger{server: s})
+		go s.retryMessages()
+	}
+	if s.Tasks != nil {
+		s.Tasks.Attach(s.ID, taskRunner{server: s})
+	}
+	if s.Reports != nil {
+		if err := s.Reports.Load(); err != nil {
+			return err
+		}
+	}
+	if s.Alerts != nil {
+		if err := s.Alerts.Load(); err != nil {
+			return err
+		}
+	}
+	if err := s.Scheduler.Load(); err != nil {
+		return err
+	}
+	if err := s.registerJobs(); err != nil {
+		return err
+	}
+	if err := s.Queue.Load(); err != nil {
+		return err
+	}
+	if err := s.registerQueueHandlers(); err != nil {
+		return err
+	}
+
+	// Weigh this node by its free space before introducing it to peers
+	s.refreshSpace()
+
 	// Start health manager
 	if s.healthManager != nil {
 		s.healthManager.Start()
@@ -479,10 +1166,25 @@ func (s *Server) Start() error {
 	if err := s.Transport.ListenAndAccept(); err != nil {
 		return err
 	}
+	s.latency.Start()
+	go s.gossipDocuments()
+	go s.sampleMetrics()
+	go s.monitorSpace()
+	s.Scheduler.Start()
+	s.Queue.Start()
+	for name, sink := range s.EventSinks {
+		go s.forwardEvents(name, sink)
+	}
+	if s.topics != nil {
+		s.topics.Start()
+	}
 	if err := s.BootstrapNetwork(); err != nil {
 		slog.Error("failed to bootstrap network", "err", err)
 		// Don't return error here as we can still function without bootstrap
 	}
+	if len(s.Seeds) > 0 {
+		go s.seed()
+	}
 
 	// Start the main loop in a goroutine so Start() can return
 	go s.loop()
@@ -491,10 +1193,30 @@ func (s *Server) Start() error {
 }
 
 func init() {
-	gob.Register(dto.StoreFile{})
-	gob.Register(dto.GetFile{})
-	gob.Register(dto.StoreFileAck{})
-	gob.Register(dto.GetFileAck{})
+	// The tags identify the messages in protobuf and CBOR and must never
+	// change; proto/p2p.proto lists them
+	codec.Register(1, dto.StoreFile{})
+	codec.Register(2, dto.GetFile{})
+	codec.Register(3, dto.StoreFileAck{})
+	codec.Register(4, dto.GetFileAck{})
+	codec.Register(5, dto.LatencyProbe{})
+	codec.Register(6, dto.LatencyReply{})
+	codec.Register(7, dto.HasFile{})
+	codec.Register(8, dto.HasFileAck{})
+	codec.Register(9, dto.NodeInfo{})
+	codec.Register(10, dto.StoreChunk{})
+	codec.Register(11, dto.FetchFile{})
+	codec.Register(12, dto.FetchChunk{})
+	codec.Register(13, dto.DocumentDigest{})
+	codec.Register(14, dto.DocumentState{})
+	codec.Register(15, dto.PieceMap{})
+	codec.Register(16, dto.PieceMapAck{})
+	codec.Register(17, dto.FetchPiece{})
+	codec.Register(18, dto.AppMessage{})
+	codec.Register(19, dto.AppReceipt{})
+	codec.Register(20, dto.TaskCall{})
+	codec.Register(21, dto.TaskData{})
+	codec.Register(22, dto.FileAttributes{})
 }
 
 // FileOperationManager manages concurrent file operations
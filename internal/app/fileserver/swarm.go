@@ -0,0 +1,665 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/dto"
+	"github.com/Skpow1234/Peervault/internal/vclock"
+	"golang.org/x/time/rate"
+)
+
+// Large files are fetched as a swarm, the way BitTorrent clients fetch
+// them: the downloader asks every peer which pieces of the file it can
+// serve, then fetches different pieces from all of them at once, rarest
+// first, so a single copy does not bound the throughput of popular files.
+// Peers still downloading the file serve the pieces they already have.
+// Each peer gets a window of pieces in flight that grows while it answers
+// and halves when it fails, and its pieces can be bounded to SwarmPeerRate
+// bytes per second. Holders keep the decrypted content of the files they
+// serve pieces of for a while, rather than decrypting them for each piece.
+
+const (
+	// DefaultSwarmMinSize is the size from which files are fetched as a
+	// swarm
+	DefaultSwarmMinSize = 8 << 20
+	// swarmPieceSize is the size of the pieces of a swarm download, sent
+	// in one message each
+	swarmPieceSize = transferChunkSize
+	// swarmQueryTimeout bounds how long a download waits for piece maps
+	swarmQueryTimeout = time.Second
+	// swarmRefreshInterval is how often a download asks for piece maps
+	// again, to learn the pieces other downloaders got meanwhile
+	swarmRefreshInterval = 2 * time.Second
+	// swarmMaxWindow is the most pieces in flight from one peer
+	swarmMaxWindow = 8
+	// swarmMaxFailures drops a peer from a download after as many failures
+	// in a row
+	swarmMaxFailures = 3
+	// swarmCacheSize bounds the decrypted files kept to serve pieces; the
+	// file served last is kept even when larger
+	swarmCacheSize = 256 << 20
+	// swarmCacheTTL is how long a file is kept after its last piece was
+	// served
+	swarmCacheTTL = time.Minute
+)
+
+// swarms tracks the swarm downloads of the node and the files it serves
+// pieces of
+type swarms struct {
+	mu        sync.Mutex
+	queries   map[string]chan pieceMapAnswer // by request ID
+	downloads map[string]*swarmDownload      // by hashed key
+	seeds     map[string]*seed               // by storage key
+	seeded    int64                          // bytes in seeds
+	expiring  bool                           // an expiry is scheduled
+
+	downloaded   atomic.Int64 // swarm downloads completed
+	piecesServed atomic.Int64
+}
+
+type pieceMapAnswer struct {
+	addr string
+	ack  dto.PieceMapAck
+}
+
+// seed is the decrypted content of a local copy serving pieces
+type seed struct {
+	data []byte
+	// size and version identify the copy the content was read from
+	size    int64
+	version vclock.Vector
+	used    time.Time
+}
+
+func newSwarms() *swarms {
+	return &swarms{
+		queries:   make(map[string]chan pieceMapAnswer),
+		downloads: make(map[string]*swarmDownload),
+		seeds:     make(map[string]*seed),
+	}
+}
+
+// deliver hands a piece map to the query waiting for it
+func (w *swarms) deliver(addr string, ack dto.PieceMapAck) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if ch, ok := w.queries[ack.RequestID]; ok {
+		select {
+		case ch <- pieceMapAnswer{addr: addr, ack: ack}:
+		default:
+		}
+	}
+}
+
+// swarmDownload is a file being fetched as a swarm, whose pieces are
+// served to other downloaders as they arrive
+type swarmDownload struct {
+	mu      sync.Mutex
+	size    int64
+	version vclock.Vector
+	data    []byte
+	have    []bool
+}
+
+func pieceCount(size int64) int {
+	return int((size + swarmPieceSize - 1) / swarmPieceSize)
+}
+
+// pieceRange returns the offsets of piece i of a file of size bytes
+func pieceRange(i int, size int64) (int64, int64) {
+	off := int64(i) * swarmPieceSize
+	return off, min(off+swarmPieceSize, size)
+}
+
+func (d *swarmDownload) put(i int, data []byte) {
+	off, _ := pieceRange(i, d.size)
+	d.mu.Lock()
+	copy(d.data[off:], data)
+	d.have[i] = true
+	d.mu.Unlock()
+}
+
+// bitmap returns the pieces downloaded so far
+func (d *swarmDownload) bitmap() []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	bits := make([]byte, (len(d.have)+7)/8)
+	for i, ok := range d.have {
+		if ok {
+			bits[i/8] |= 1 << (i % 8)
+		}
+	}
+	return bits
+}
+
+// read returns length bytes from off if every piece they span arrived
+func (d *swarmDownload) read(off, length int64) ([]byte, bool) {
+	if off < 0 || length <= 0 || off+length > d.size {
+		return nil, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := int(off / swarmPieceSize); int64(i)*swarmPieceSize < off+length; i++ {
+		if !d.have[i] {
+			return nil, false
+		}
+	}
+	// Pieces that arrived are never written again
+	return d.data[off : off+length], true
+}
+
+// swarmPeer is a peer serving pieces to a download
+type swarmPeer struct {
+	addr     string
+	complete bool
+	pieces   []bool // pieces a peer still downloading has
+	dropped  bool
+	window   int
+	inflight map[int]bool
+	failures int
+	limiter  *rate.Limiter // nil when unlimited
+}
+
+func (p *swarmPeer) has(i int) bool {
+	return !p.dropped && (p.complete || (i < len(p.pieces) && p.pieces[i]))
+}
+
+// update takes in the piece map of the peer
+func (p *swarmPeer) update(ack dto.PieceMapAck, n int) {
+	p.complete = ack.Complete
+	p.pieces = nil
+	if ack.Complete || ack.PieceSize != swarmPieceSize {
+		return
+	}
+	p.pieces = make([]bool, n)
+	for i := range n {
+		p.pieces[i] = i/8 < len(ack.Pieces) && ack.Pieces[i/8]&(1<<(i%8)) != 0
+	}
+}
+
+type pieceResult struct {
+	peer  *swarmPeer
+	index int
+	data  []byte
+	err   error
+}
+
+// swarmMinSize returns the size from which files are fetched as a swarm,
+// or -1 when swarming is off
+func (s *Server) swarmMinSize() int64 {
+	switch {
+	case s.SwarmMinSize < 0:
+		return -1
+	case s.SwarmMinSize == 0:
+		return DefaultSwarmMinSize
+	default:
+		return s.SwarmMinSize
+	}
+}
+
+// swarmFetch fetches a file from every peer holding it at once. It
+// reports false, leaving the file to be fetched from one peer, when the
+// file is too small or fewer than two peers can serve it.
+func (s *Server) swarmFetch(ctx context.Context, hashedKey string) ([]byte, bool, error) {
+	minSize := s.swarmMinSize()
+	if minSize < 0 {
+		return nil, false, nil
+	}
+	answers, err := s.queryPieceMaps(ctx, hashedKey)
+	if err != nil {
+		return nil, false, err
+	}
+	size, version, sources := pickSwarm(answers)
+	if size < minSize || len(sources) < 2 {
+		return nil, false, nil
+	}
+
+	d := &swarmDownload{size: size, version: version, data: make([]byte, size), have: make([]bool, pieceCount(size))}
+	s.swarm.mu.Lock()
+	if _, ok := s.swarm.downloads[hashedKey]; !ok {
+		s.swarm.downloads[hashedKey] = d
+		defer func() {
+			s.swarm.mu.Lock()
+			delete(s.swarm.downloads, hashedKey)
+			s.swarm.mu.Unlock()
+		}()
+	}
+	s.swarm.mu.Unlock()
+
+	start := time.Now()
+	if err := s.runSwarm(ctx, hashedKey, d, sources); err != nil {
+		return nil, true, err
+	}
+	s.swarm.downloaded.Add(1)
+	slog.Info("fetched file from swarm", "key", hashedKey, "bytes", size, "peers", len(sources), "duration", time.Since(start))
+	return d.data, true, nil
+}
+
+// pickSwarm returns the size and version of the file most peers hold
+// completely, and the peers serving pieces of that copy
+func pickSwarm(answers []pieceMapAnswer) (int64, vclock.Vector, []pieceMapAnswer) {
+	type group struct {
+		size     int64
+		version  vclock.Vector
+		complete int
+		answers  []pieceMapAnswer
+	}
+	var groups []*group
+	for _, a := range answers {
+		if a.ack.Size < 0 {
+			continue
+		}
+		var g *group
+		for _, candidate := range groups {
+			if candidate.size == a.ack.Size && candidate.version.Compare(a.ack.Version) == vclock.Equal {
+				g = candidate
+				break
+			}
+		}
+		if g == nil {
+			g = &group{size: a.ack.Size, version: a.ack.Version}
+			groups = append(groups, g)
+		}
+		g.answers = append(g.answers, a)
+		if a.ack.Complete {
+			g.complete++
+		}
+	}
+
+	var best *group
+	for _, g := range groups {
+		if g.complete > 0 && (best == nil || g.complete > best.complete ||
+			(g.complete == best.complete && len(g.answers) > len(best.answers))) {
+			best = g
+		}
+	}
+	if best == nil {
+		return -1, nil, nil
+	}
+	return best.size, best.version, best.answers
+}
+
+// runSwarm fetches the pieces of d from the sources until it has them all
+func (s *Server) runSwarm(ctx context.Context, hashedKey string, d *swarmDownload, sources []pieceMapAnswer) error {
+	n := len(d.have)
+	peers := make(map[string]*swarmPeer, len(sources))
+	var order []string
+	addPeer := func(a pieceMapAnswer) {
+		p := &swarmPeer{addr: a.addr, window: 1, inflight: make(map[int]bool)}
+		if s.SwarmPeerRate > 0 {
+			p.limiter = rate.NewLimiter(rate.Limit(s.SwarmPeerRate), int(max(s.SwarmPeerRate, swarmPieceSize)))
+		}
+		p.update(a.ack, n)
+		peers[a.addr] = p
+		order = append(order, a.addr)
+	}
+	for _, a := range sources {
+		addPeer(a)
+	}
+	s.latency.SortByLatency(order)
+
+	// avail counts the peers able to serve each piece
+	avail := make([]int, n)
+	recount := func() {
+		clear(avail)
+		for _, p := range peers {
+			for i := range n {
+				if p.has(i) {
+					avail[i]++
+				}
+			}
+		}
+	}
+	recount()
+
+	have := make([]bool, n)
+	requested := make([]int, n)
+	remaining := n
+	pick := func(p *swarmPeer) int {
+		best := -1
+		first := rand.IntN(n)
+		for k := range n {
+			i := (first + k) % n
+			if !have[i] && requested[i] == 0 && p.has(i) && (best < 0 || avail[i] < avail[best]) {
+				best = i
+			}
+		}
+		if best >= 0 {
+			return best
+		}
+		// Endgame: once every missing piece is in flight, ask another peer
+		// for it too so one slow peer does not hold up the end
+		for k := range n {
+			i := (first + k) % n
+			if !have[i] && requested[i] == 1 && !p.inflight[i] && p.has(i) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	// Pieces still in flight at the end are not waited for
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+	results := make(chan pieceResult)
+	refreshed := make(chan []pieceMapAnswer, 1)
+	refresh := time.NewTicker(swarmRefreshInterval)
+	defer refresh.Stop()
+	refreshing := false
+	inflight := 0
+
+	for remaining > 0 {
+		for _, addr := range order {
+			p := peers[addr]
+			for len(p.inflight) < p.window {
+				i := pick(p)
+				if i < 0 {
+					break
+				}
+				p.inflight[i] = true
+				requested[i]++
+				inflight++
+				off, end := pieceRange(i, d.size)
+				go func() {
+					data, err := s.fetchPiece(ctx, p, hashedKey, off, end-off)
+					select {
+					case results <- pieceResult{peer: p, index: i, data: data, err: err}:
+					case <-done:
+					}
+				}()
+			}
+		}
+		if inflight == 0 {
+			return fmt.Errorf("no peer serves the %d missing pieces", remaining)
+		}
+
+		select {
+		case r := <-results:
+			p, i := r.peer, r.index
+			delete(p.inflight, i)
+			requested[i]--
+			inflight--
+			off, end := pieceRange(i, d.size)
+			if r.err == nil && int64(len(r.data)) != end-off {
+				r.err = fmt.Errorf("peer %s sent %d bytes of piece %d", p.addr, len(r.data), i)
+			}
+			switch {
+			case r.err == nil:
+				if !have[i] {
+					d.put(i, r.data)
+					have[i] = true
+					remaining--
+				}
+				p.failures = 0
+				p.window = min(p.window+1, swarmMaxWindow)
+			case ctx.Err() != nil:
+				return ctx.Err()
+			case errors.Is(r.err, errNotHeld) && !p.complete:
+				// The downloader lost the piece or has not got it yet
+				if i < len(p.pieces) && p.pieces[i] {
+					p.pieces[i] = false
+					avail[i]--
+				}
+			default:
+				p.window = max(p.window/2, 1)
+				p.failures++
+				if p.failures >= swarmMaxFailures || errors.Is(r.err, errNotHeld) {
+					slog.Warn("dropped peer from swarm download", "key", hashedKey, "peer", p.addr, "error", r.err)
+					p.dropped = true
+					recount()
+				}
+			}
+		case answers := <-refreshed:
+			refreshing = false
+			for _, a := range answers {
+				if a.ack.Size != d.size || d.version.Compare(a.ack.Version) != vclock.Equal {
+					continue
+				}
+				if p, ok := peers[a.addr]; ok {
+					if !p.dropped {
+						p.update(a.ack, n)
+					}
+				} else {
+					addPeer(a)
+				}
+			}
+			recount()
+		case <-refresh.C:
+			if !refreshing {
+				refreshing = true
+				go func() {
+					answers, _ := s.queryPieceMaps(ctx, hashedKey)
+					refreshed <- answers
+				}()
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// queryPieceMaps asks every peer for its piece map of a file and collects
+// the answers of peers that have some of it, until all peers answered or
+// swarmQueryTimeout passed
+func (s *Server) queryPieceMaps(ctx context.Context, hashedKey string) ([]pieceMapAnswer, error) {
+	addrs := s.peerAddrs()
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+
+	requestID := crypto.GenerateID()
+	ch := make(chan pieceMapAnswer, len(addrs))
+	s.swarm.mu.Lock()
+	s.swarm.queries[requestID] = ch
+	s.swarm.mu.Unlock()
+	defer func() {
+		s.swarm.mu.Lock()
+		delete(s.swarm.queries, requestID)
+		s.swarm.mu.Unlock()
+	}()
+
+	asked := 0
+	for _, addr := range addrs {
+		if err := s.send(addr, &Message{Payload: dto.PieceMap{RequestID: requestID, Key: hashedKey}}); err == nil {
+			asked++
+		}
+	}
+
+	timeout := time.NewTimer(swarmQueryTimeout)
+	defer timeout.Stop()
+	var answers []pieceMapAnswer
+	for range asked {
+		select {
+		case a := <-ch:
+			if a.ack.Size >= 0 {
+				answers = append(answers, a)
+			}
+		case <-timeout.C:
+			return answers, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return answers, nil
+}
+
+// fetchPiece fetches length bytes of a file from off from the peer p
+func (s *Server) fetchPiece(ctx context.Context, p *swarmPeer, hashedKey string, off, length int64) ([]byte, error) {
+	if p.limiter != nil {
+		if err := p.limiter.WaitN(ctx, int(length)); err != nil {
+			return nil, err
+		}
+	}
+
+	requestID := crypto.GenerateID()
+	state, end := s.transfers.openFetch(requestID)
+	defer end()
+	msg := dto.FetchPiece{RequestID: requestID, Key: hashedKey, Offset: off, Length: length}
+	if err := s.send(p.addr, &Message{Payload: msg}); err != nil {
+		return nil, err
+	}
+
+	timeout := time.NewTimer(transferTimeout)
+	defer timeout.Stop()
+	select {
+	case chunk := <-state.chunks:
+		if chunk.Missing {
+			return nil, errNotHeld
+		}
+		return chunk.Data, nil
+	case <-timeout.C:
+		return nil, fmt.Errorf("peer %s did not send the piece at %d of %s", p.addr, off, hashedKey)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// handleMessagePieceMap tells a peer which pieces of a file this node can
+// serve. Reading the size of a stored file decrypts it, which the pieces
+// asked for next need anyway, so it is done in the background.
+func (s *Server) handleMessagePieceMap(from string, msg dto.PieceMap) error {
+	ack := dto.PieceMapAck{RequestID: msg.RequestID, ID: s.ID, Key: msg.Key, Size: -1, PieceSize: swarmPieceSize}
+	storageKey := s.placement.storageKey(msg.Key)
+	if !s.store.Has(storageKey) {
+		s.swarm.mu.Lock()
+		d := s.swarm.downloads[msg.Key]
+		s.swarm.mu.Unlock()
+		if d != nil {
+			ack.Size, ack.Pieces, ack.Version = d.size, d.bitmap(), d.version
+		}
+		return s.send(from, &Message{Payload: ack})
+	}
+
+	go func() {
+		if data, version, err := s.seedData(msg.Key, storageKey); err != nil {
+			slog.Warn("failed to read file for swarm", "key", msg.Key, "peer", from, "error", err)
+		} else {
+			ack.Size, ack.Complete, ack.Version = int64(len(data)), true, version
+		}
+		_ = s.send(from, &Message{Payload: ack})
+	}()
+	return nil
+}
+
+// handleMessageFetchPiece sends a piece of a file, from the local copy or
+// from the pieces of a download in progress
+func (s *Server) handleMessageFetchPiece(from string, msg dto.FetchPiece) error {
+	go func() {
+		chunk := dto.FetchChunk{RequestID: msg.RequestID, Final: true, Missing: true}
+		if data, ok := s.piece(msg.Key, msg.Offset, msg.Length); ok {
+			chunk.Data, chunk.Missing = data, false
+			s.swarm.piecesServed.Add(1)
+		}
+		if err := s.send(from, &Message{Payload: chunk}); err != nil {
+			slog.Warn("failed to send piece to peer", "key", msg.Key, "peer", from, "error", err)
+		}
+	}()
+	return nil
+}
+
+// piece returns length bytes of a file from off, if this node has them
+func (s *Server) piece(hashedKey string, off, length int64) ([]byte, bool) {
+	if length > swarmPieceSize {
+		return nil, false
+	}
+	if storageKey := s.placement.storageKey(hashedKey); s.store.Has(storageKey) {
+		data, _, err := s.seedData(hashedKey, storageKey)
+		if err != nil || off < 0 || length <= 0 || off+length > int64(len(data)) {
+			return nil, false
+		}
+		return data[off : off+length], true
+	}
+	s.swarm.mu.Lock()
+	d := s.swarm.downloads[hashedKey]
+	s.swarm.mu.Unlock()
+	if d == nil {
+		return nil, false
+	}
+	return d.read(off, length)
+}
+
+// seedData returns the decrypted content of the local copy stored under
+// storageKey and its version, reading it again once the copy changed
+func (s *Server) seedData(hashedKey, storageKey string) ([]byte, vclock.Vector, error) {
+	size, r, err := s.store.Read(storageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	_ = r.Close()
+	version := s.versions.vector(hashedKey)
+
+	w := s.swarm
+	w.mu.Lock()
+	if c, ok := w.seeds[storageKey]; ok && c.size == size && c.version.Compare(version) == vclock.Equal {
+		c.used = time.Now()
+		w.mu.Unlock()
+		return c.data, version, nil
+	}
+	w.mu.Unlock()
+
+	data, err := s.readDecrypted(storageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if old, ok := w.seeds[storageKey]; ok {
+		w.seeded -= int64(len(old.data))
+	}
+	w.seeds[storageKey] = &seed{data: data, size: size, version: version, used: time.Now()}
+	w.seeded += int64(len(data))
+	w.evict(storageKey)
+	if !w.expiring {
+		w.expiring = true
+		time.AfterFunc(swarmCacheTTL, w.expire)
+	}
+	return data, version, nil
+}
+
+// evict drops the files least recently served until the seeds fit in
+// swarmCacheSize, keeping the file stored under keep. The caller holds mu.
+func (w *swarms) evict(keep string) {
+	for w.seeded > swarmCacheSize {
+		oldest := ""
+		for key, c := range w.seeds {
+			if key != keep && (oldest == "" || c.used.Before(w.seeds[oldest].used)) {
+				oldest = key
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		w.seeded -= int64(len(w.seeds[oldest].data))
+		delete(w.seeds, oldest)
+	}
+}
+
+// expire drops the files no piece was served of for swarmCacheTTL, and
+// runs again while files are left
+func (w *swarms) expire() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	cutoff := time.Now().Add(-swarmCacheTTL)
+	for key, c := range w.seeds {
+		if c.used.Before(cutoff) {
+			w.seeded -= int64(len(c.data))
+			delete(w.seeds, key)
+		}
+	}
+	if len(w.seeds) > 0 {
+		time.AfterFunc(swarmCacheTTL, w.expire)
+	} else {
+		w.expiring = false
+	}
+}
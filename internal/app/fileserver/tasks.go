@@ -0,0 +1,68 @@
+package fileserver
+
+import (
+	"fmt"
+
+	"github.com/Skpow1234/Peervault/internal/dto"
+	"github.com/Skpow1234/Peervault/internal/tasks"
+)
+
+// taskRunner carries the task calls of the node and their streams to the
+// peers on the ring, by node ID
+type taskRunner struct{ server *Server }
+
+func (r taskRunner) SendCall(node string, c tasks.Call) error {
+	addr, ok := r.server.nodeAddr(node)
+	if !ok {
+		return fmt.Errorf("%w: %s", tasks.ErrUnreachable, node)
+	}
+	return r.server.send(addr, &Message{Payload: dto.TaskCall{CallID: c.ID, Handler: c.Handler, Args: c.Args}})
+}
+
+func (r taskRunner) SendData(node string, d tasks.Data) error {
+	addr, ok := r.server.nodeAddr(node)
+	if !ok {
+		return fmt.Errorf("%w: %s", tasks.ErrUnreachable, node)
+	}
+	return r.server.send(addr, &Message{Payload: dto.TaskData{
+		CallID: d.Call, ToHost: d.ToHost, Data: d.Data, Credit: d.Credit, Final: d.Final, Code: d.Code, Error: d.Error,
+	}})
+}
+
+// addrNode returns the ID of the node reached at addr, which is only known
+// once it announced itself
+func (s *Server) addrNode(addr string) (string, bool) {
+	p := s.placement
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, a := range p.addrs {
+		if a == addr {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// handleMessageTaskCall runs a task for a peer. The caller is identified
+// by the node ID it announced, not by anything in the call, and nodes
+// without tasks or that do not know the caller refuse it.
+func (s *Server) handleMessageTaskCall(from string, msg dto.TaskCall) error {
+	node, ok := s.addrNode(from)
+	if s.Tasks == nil || !ok {
+		return s.send(from, &Message{Payload: dto.TaskData{
+			CallID: msg.CallID, Final: true, Code: "denied", Error: "tasks are not enabled or the caller is unknown",
+		}})
+	}
+	s.Tasks.HandleCall(node, tasks.Call{ID: msg.CallID, Handler: msg.Handler, Args: msg.Args})
+	return nil
+}
+
+func (s *Server) handleMessageTaskData(from string, msg dto.TaskData) {
+	node, ok := s.addrNode(from)
+	if s.Tasks == nil || !ok {
+		return
+	}
+	s.Tasks.HandleData(node, tasks.Data{
+		Call: msg.CallID, ToHost: msg.ToHost, Data: msg.Data, Credit: msg.Credit, Final: msg.Final, Code: msg.Code, Error: msg.Error,
+	})
+}
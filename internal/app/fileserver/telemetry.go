@@ -0,0 +1,57 @@
+package fileserver
+
+import (
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/lwm2m"
+	"github.com/Skpow1234/Peervault/internal/telemetry"
+)
+
+// telemetryFlushInterval is how often telemetry rollups are persisted
+const telemetryFlushInterval = time.Minute
+
+// recordObservation records the numeric values an LwM2M device reports for
+// its observed paths as telemetry, under the device's endpoint name and the
+// resource path, such as /3303/0/5700. Resources of objects outside the
+// core model are read as text, so numbers in text are recorded too.
+func (s *Server) recordObservation(endpoint string, values []lwm2m.Value) {
+	for _, v := range values {
+		var value float64
+		switch n := v.Value.(type) {
+		case int64:
+			value = float64(n)
+		case float64:
+			value = n
+		case string:
+			var err error
+			if value, err = strconv.ParseFloat(n, 64); err != nil {
+				continue
+			}
+		default:
+			continue
+		}
+		if err := s.Telemetry.Record(telemetry.Reading{Device: endpoint, Metric: v.Path, Value: value}); err != nil {
+			slog.Debug("dropped LwM2M telemetry", "endpoint", endpoint, "path", v.Path, "error", err)
+		}
+	}
+}
+
+// flushTelemetry persists the telemetry rollups every
+// telemetryFlushInterval until the server stops, which flushes them a last
+// time
+func (s *Server) flushTelemetry() {
+	ticker := time.NewTicker(telemetryFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Telemetry.Flush(); err != nil {
+				slog.Warn("failed to persist telemetry", "error", err)
+			}
+		case <-s.quitch:
+			return
+		}
+	}
+}
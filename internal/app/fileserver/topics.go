@@ -0,0 +1,52 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Skpow1234/Peervault/internal/pubsub"
+)
+
+// Topics returns the durable topics of the node, nil when it keeps none.
+// Every API of the node publishes to and consumes the same topics, so a
+// message published over one protocol reaches consumers of the others.
+func (s *Server) Topics() *pubsub.Topics { return s.topics }
+
+// objectStore stores the logs and offsets of durable topics, and firmware
+// images, as files of the node, so they are replicated like any other file
+type objectStore struct {
+	server *Server
+}
+
+func (o objectStore) Has(key string) bool {
+	_, ok := o.server.localKey(key)
+	return ok
+}
+
+func (o objectStore) Get(ctx context.Context, key string) ([]byte, error) {
+	// Checked first so a missing object isn't requested from every peer
+	if !o.Has(key) {
+		return nil, fmt.Errorf("object not available: %s", key)
+	}
+	r, err := o.server.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func (o objectStore) Put(ctx context.Context, key string, data []byte) error {
+	// The store does not overwrite objects
+	if o.server.store.Has(key) {
+		if err := o.server.store.Delete(key); err != nil {
+			return err
+		}
+	}
+	return o.server.storeFile(ctx, key, bytes.NewReader(data), nil, nil)
+}
+
+func (o objectStore) Delete(ctx context.Context, key string) error {
+	return o.server.Delete(ctx, key)
+}
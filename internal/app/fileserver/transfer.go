@@ -0,0 +1,348 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/analytics"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/dto"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+)
+
+const (
+	// transferChunkSize is the largest part of a file sent in one message,
+	// well under the frame size limit of the transport
+	transferChunkSize = 1 << 20
+	// transferTimeout bounds how long a push waits for its acknowledgement
+	// and a fetch waits for the next chunk
+	transferTimeout = 30 * time.Second
+)
+
+// errNotHeld is returned by fetch when the peer does not hold the file
+var errNotHeld = errors.New("peer does not hold the file")
+
+// Files travel between peers as messages of at most transferChunkSize
+// bytes. The sender decrypts its copy and the receiver encrypts it with its
+// own key, as every node keeps its copies encrypted at rest.
+type transfers struct {
+	mu       sync.Mutex
+	acks     map[string]chan dto.StoreFileAck // by request ID
+	fetches  map[string]*fetchState           // by request ID
+	incoming map[string]*incomingFile         // by peer address and request ID
+}
+
+type fetchState struct {
+	chunks chan dto.FetchChunk
+	done   chan struct{}
+}
+
+// incomingFile is a file being pushed to this node
+type incomingFile struct {
+	from string
+	key  string
+	// userKey is the key of the file as its user stored it, when the
+	// sender knows it
+	userKey  string
+	tags     []string
+	metadata map[string]string
+	version  map[string]uint64
+	data     bytes.Buffer
+	// refused is why the file is not stored; its chunks are dropped
+	refused error
+}
+
+func newTransfers() *transfers {
+	return &transfers{
+		acks:     make(map[string]chan dto.StoreFileAck),
+		fetches:  make(map[string]*fetchState),
+		incoming: make(map[string]*incomingFile),
+	}
+}
+
+// dropPeer discards the files a disconnected peer was pushing
+func (t *transfers) dropPeer(addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, f := range t.incoming {
+		if f.from == addr {
+			delete(t.incoming, id)
+		}
+	}
+}
+
+// openFetch registers a fetch expecting FetchChunks with the request ID,
+// returning the function that ends it
+func (t *transfers) openFetch(requestID string) (*fetchState, func()) {
+	state := &fetchState{chunks: make(chan dto.FetchChunk, 16), done: make(chan struct{})}
+	t.mu.Lock()
+	t.fetches[requestID] = state
+	t.mu.Unlock()
+	return state, func() {
+		t.mu.Lock()
+		delete(t.fetches, requestID)
+		t.mu.Unlock()
+		close(state.done)
+	}
+}
+
+// readDecrypted reads and decrypts a local copy, or returns it from the
+// read cache. The content is shared with the cache and must not be
+// modified.
+func (s *Server) readDecrypted(storageKey string) ([]byte, error) {
+	if s.ReadCache != nil {
+		if data, ok := s.ReadCache.Get(storageKey); ok {
+			return data, nil
+		}
+	}
+	data, err := s.decryptStored(storageKey)
+	if err != nil {
+		return nil, err
+	}
+	if s.ReadCache != nil {
+		s.ReadCache.Put(storageKey, data, int64(len(data)))
+	}
+	return data, nil
+}
+
+// decryptStored reads and decrypts a local copy from the store
+func (s *Server) decryptStored(storageKey string) ([]byte, error) {
+	_, encryptedReader, err := s.store.Read(storageKey)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = encryptedReader.Close() }()
+
+	var decrypted bytes.Buffer
+	if s.KeyManager != nil {
+		_, _, err = s.KeyManager.CopyDecrypt(encryptedReader, &decrypted)
+	} else {
+		_, err = crypto.CopyDecrypt(s.EncKey, encryptedReader, &decrypted)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt file: %w", err)
+	}
+	return decrypted.Bytes(), nil
+}
+
+// writeEncrypted stores a local copy encrypted at rest, with the key of the
+// current generation when the node has a key manager
+func (s *Server) writeEncrypted(storageKey string, r io.Reader) (int64, error) {
+	defer s.uncache(storageKey)
+	if s.KeyManager == nil {
+		return s.store.WriteDecrypt(crypto.CopyEncrypt, s.EncKey, storageKey, r)
+	}
+	encrypt := func(_ []byte, src io.Reader, dst io.Writer) (int, error) {
+		return s.KeyManager.CopyEncrypt(src, dst)
+	}
+	return s.store.WriteDecrypt(encrypt, nil, storageKey, r)
+}
+
+// deleteStored deletes a local copy and its cached content
+func (s *Server) deleteStored(storageKey string) error {
+	defer s.uncache(storageKey)
+	return s.store.Delete(storageKey)
+}
+
+// uncache drops the cached content of a local copy that changed
+func (s *Server) uncache(storageKey string) {
+	if s.ReadCache != nil {
+		s.ReadCache.Remove(storageKey)
+	}
+}
+
+// push sends the local copy stored under storageKey to the peer at addr,
+// which stores it under hashedKey, and waits for the peer to confirm.
+// Copies the Policy denies are not sent.
+func (s *Server) push(ctx context.Context, addr, hashedKey, storageKey string, tags []string, attrs map[string]string) error {
+	data, err := s.readDecrypted(storageKey)
+	if err != nil {
+		return err
+	}
+	if err := s.checkReplica(addr, storageKey, data, tags, attrs); err != nil {
+		return err
+	}
+
+	requestID := crypto.GenerateID()
+	ack := make(chan dto.StoreFileAck, 1)
+	s.transfers.mu.Lock()
+	s.transfers.acks[requestID] = ack
+	s.transfers.mu.Unlock()
+	defer func() {
+		s.transfers.mu.Lock()
+		delete(s.transfers.acks, requestID)
+		s.transfers.mu.Unlock()
+	}()
+
+	for off := 0; ; off += transferChunkSize {
+		end := min(off+transferChunkSize, len(data))
+		chunk := dto.StoreChunk{RequestID: requestID, Key: hashedKey, Data: data[off:end], Final: end == len(data)}
+		if off == 0 {
+			chunk.Tags, chunk.Metadata, chunk.Version = tags, attrs, s.versions.vector(hashedKey)
+			chunk.UserKey = s.versions.userKey(hashedKey)
+		}
+		if err := s.send(addr, &Message{Payload: chunk}); err != nil {
+			return err
+		}
+		if chunk.Final {
+			break
+		}
+	}
+
+	timeout := time.NewTimer(transferTimeout)
+	defer timeout.Stop()
+	select {
+	case a := <-ack:
+		if !a.Success {
+			return fmt.Errorf("peer %s failed to store %s: %s", addr, hashedKey, a.Error)
+		}
+		return nil
+	case <-timeout.C:
+		return fmt.Errorf("peer %s did not confirm %s", addr, hashedKey)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handleMessageStoreChunk collects the chunks of a pushed file and stores
+// it once the last one arrives
+func (s *Server) handleMessageStoreChunk(from string, msg dto.StoreChunk) error {
+	id := from + "/" + msg.RequestID
+	s.transfers.mu.Lock()
+	f, ok := s.transfers.incoming[id]
+	if !ok {
+		f = &incomingFile{from: from, key: msg.Key, tags: msg.Tags, metadata: msg.Metadata, version: msg.Version}
+		// A user key is only taken for the file it names
+		if msg.UserKey != "" && crypto.HashKey(msg.UserKey) == msg.Key {
+			f.userKey = msg.UserKey
+		}
+		f.refused = s.checkSpace(s.placement.storageKey(msg.Key))
+		s.transfers.incoming[id] = f
+	}
+	if f.refused == nil {
+		f.data.Write(msg.Data)
+	}
+	if !msg.Final {
+		s.transfers.mu.Unlock()
+		return nil
+	}
+	delete(s.transfers.incoming, id)
+	s.transfers.mu.Unlock()
+
+	ack := dto.StoreFileAck{RequestID: msg.RequestID, Key: f.key, Success: true}
+	if f.refused != nil {
+		slog.Warn("refused replica", "key", f.key, "peer", from, "error", f.refused)
+		ack.Success, ack.Error = false, f.refused.Error()
+		return s.send(from, &Message{Payload: ack})
+	}
+	size := int64(f.data.Len())
+	n, stored, err := s.storeReplica(from, f)
+	if err != nil {
+		ack.Success, ack.Error = false, err.Error()
+	} else if stored {
+		s.consumeSpace(n)
+		// Records are kept by the key users know files by
+		if f.userKey != "" && (len(f.tags) > 0 || len(f.metadata) > 0) {
+			s.recordAttributes(metadata.FileRecord{Key: f.userKey, HashedKey: f.key, Size: n, Tags: f.tags, Metadata: f.metadata})
+		}
+		s.recordAccess(analytics.OpWrite, f.key, from, size)
+		slog.Info("stored replica", "key", f.key, "bytes", n, "peer", from)
+	}
+	return s.send(from, &Message{Payload: ack})
+}
+
+// handleMessageStoreFileAck hands a push acknowledgement to the push
+// waiting for it
+func (s *Server) handleMessageStoreFileAck(msg dto.StoreFileAck) {
+	s.transfers.mu.Lock()
+	defer s.transfers.mu.Unlock()
+	if ch, ok := s.transfers.acks[msg.RequestID]; ok {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// fetch downloads a file from the peer at addr
+func (s *Server) fetch(ctx context.Context, addr, hashedKey string) ([]byte, error) {
+	requestID := crypto.GenerateID()
+	state, end := s.transfers.openFetch(requestID)
+	defer end()
+
+	if err := s.send(addr, &Message{Payload: dto.FetchFile{RequestID: requestID, Key: hashedKey}}); err != nil {
+		return nil, err
+	}
+
+	var data bytes.Buffer
+	timeout := time.NewTimer(transferTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case chunk := <-state.chunks:
+			if chunk.Missing {
+				return nil, errNotHeld
+			}
+			data.Write(chunk.Data)
+			if chunk.Final {
+				return data.Bytes(), nil
+			}
+			timeout.Reset(transferTimeout)
+		case <-timeout.C:
+			return nil, fmt.Errorf("peer %s stopped sending %s", addr, hashedKey)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// handleMessageFetchChunk hands a chunk to the fetch waiting for it
+func (s *Server) handleMessageFetchChunk(msg dto.FetchChunk) {
+	s.transfers.mu.Lock()
+	state, ok := s.transfers.fetches[msg.RequestID]
+	s.transfers.mu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case state.chunks <- msg:
+	case <-state.done:
+	}
+}
+
+// handleMessageFetchFile sends a file to the peer asking for it. The file
+// is sent in the background so the message loop keeps serving the peer.
+func (s *Server) handleMessageFetchFile(from string, msg dto.FetchFile) error {
+	storageKey := s.placement.storageKey(msg.Key)
+	if !s.store.Has(storageKey) {
+		return s.send(from, &Message{Payload: dto.FetchChunk{RequestID: msg.RequestID, Final: true, Missing: true}})
+	}
+
+	go func() {
+		data, err := s.readDecrypted(storageKey)
+		if err != nil {
+			slog.Warn("failed to read file for peer", "key", msg.Key, "peer", from, "error", err)
+			_ = s.send(from, &Message{Payload: dto.FetchChunk{RequestID: msg.RequestID, Final: true, Missing: true}})
+			return
+		}
+		for off := 0; ; off += transferChunkSize {
+			end := min(off+transferChunkSize, len(data))
+			chunk := dto.FetchChunk{RequestID: msg.RequestID, Data: data[off:end], Final: end == len(data)}
+			if err := s.send(from, &Message{Payload: chunk}); err != nil {
+				slog.Warn("failed to send file to peer", "key", msg.Key, "peer", from, "error", err)
+				return
+			}
+			if chunk.Final {
+				s.recordAccess(analytics.OpRead, msg.Key, from, int64(len(data)))
+				return
+			}
+		}
+	}()
+	return nil
+}
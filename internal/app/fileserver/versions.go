@@ -0,0 +1,560 @@
+package fileserver
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/events"
+	"github.com/Skpow1234/Peervault/internal/retention"
+	"github.com/Skpow1234/Peervault/internal/vclock"
+)
+
+var (
+	// ErrFileNotHeld is returned for files this node holds no copy of
+	ErrFileNotHeld = errors.New("file not found on this node")
+	// ErrVersionNotFound is returned for versions a file does not have
+	ErrVersionNotFound = errors.New("version not found")
+	// ErrNoConflict is returned when resolving a file without concurrent
+	// versions
+	ErrNoConflict = errors.New("file has no conflicting versions")
+)
+
+// FileVersion is one version of a file held by this node
+type FileVersion struct {
+	// ID identifies the version among the versions of the file
+	ID     string        `json:"id"`
+	Vector vclock.Vector `json:"vector"`
+	// Current versions are the ones served for the file; the others
+	// conflict with it
+	Current bool  `json:"current"`
+	Size    int64 `json:"size"`
+	// From is the address of the peer the version came from; empty for
+	// versions written on this node
+	From      string    `json:"from,omitempty"`
+	WrittenAt time.Time `json:"written_at"`
+}
+
+// FileVersions are the versions of a file held by this node; more than one
+// means they were written concurrently and conflict
+type FileVersions struct {
+	// Key is empty for files this node only holds a replica of, as
+	// replicas are known by their hashed key
+	Key       string        `json:"key,omitempty"`
+	HashedKey string        `json:"hashed_key"`
+	Versions  []FileVersion `json:"versions"`
+}
+
+// Every copy of a file carries a version vector, which the node writing it
+// increments and pushes send along. A node receiving a push replaces its
+// copy when the pushed version descends from it and ignores versions its
+// copy descends from. Concurrent versions are kept next to the copy until
+// a version descending from all of them, picked or merged by a user,
+// replaces them.
+type versionTable struct {
+	mu     sync.Mutex
+	path   string
+	keys   map[string]*keyVersions // by hashed key
+	loaded bool
+}
+
+type keyVersions struct {
+	Key      string          `json:"key,omitempty"`
+	Current  storedVersion   `json:"current"`
+	Siblings []storedVersion `json:"siblings,omitempty"`
+}
+
+type storedVersion struct {
+	Vector     vclock.Vector `json:"vector"`
+	StorageKey string        `json:"storage_key"`
+	Size       int64         `json:"size"`
+	From       string        `json:"from,omitempty"`
+	WrittenAt  time.Time     `json:"written_at"`
+}
+
+func (v storedVersion) public(current bool) FileVersion {
+	return FileVersion{ID: v.Vector.ID(), Vector: v.Vector.Clone(), Current: current, Size: v.Size, From: v.From, WrittenAt: v.WrittenAt}
+}
+
+func (kv *keyVersions) public(hashedKey string) FileVersions {
+	c := FileVersions{Key: kv.Key, HashedKey: hashedKey, Versions: []FileVersion{kv.Current.public(true)}}
+	for _, sib := range kv.Siblings {
+		c.Versions = append(c.Versions, sib.public(false))
+	}
+	return c
+}
+
+// merged returns the smallest vector descending from every version
+func (kv *keyVersions) merged() vclock.Vector {
+	v := kv.Current.Vector.Clone()
+	for _, sib := range kv.Siblings {
+		v = v.Merge(sib.Vector)
+	}
+	return v
+}
+
+// versionIndexFormat is the format of the persisted version table. Tables
+// written before it was recorded are a bare map of the keys.
+const versionIndexFormat = 1
+
+// versionIndex is the persisted version table
+type versionIndex struct {
+	Format int                     `json:"format"`
+	Keys   map[string]*keyVersions `json:"keys"`
+}
+
+func newVersionTable(path string) *versionTable {
+	return &versionTable{path: path, keys: make(map[string]*keyVersions)}
+}
+
+// siblingKey is the storage key of a version conflicting with the copy of
+// a file
+func siblingKey(hashedKey string, v vclock.Vector) string {
+	return hashedKey + "~" + v.ID()
+}
+
+// vector returns the version of the local copy of a file
+func (t *versionTable) vector(hashedKey string) vclock.Vector {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if kv, ok := t.keys[hashedKey]; ok {
+		return kv.Current.Vector.Clone()
+	}
+	return nil
+}
+
+// userKey returns the key of a file as its user stored it, or "" when this
+// node does not know it
+func (t *versionTable) userKey(hashedKey string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if kv, ok := t.keys[hashedKey]; ok {
+		return kv.Key
+	}
+	return ""
+}
+
+// conflicted reports whether a file has concurrent versions
+func (t *versionTable) conflicted(hashedKey string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	kv, ok := t.keys[hashedKey]
+	return ok && len(kv.Siblings) > 0
+}
+
+// wroteVersion records a write on this node, which descends from the local
+// copy it replaces but not from the versions conflicting with it
+func (s *Server) wroteVersion(key, hashedKey string, size int64) {
+	t := s.versions
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	kv, ok := t.keys[hashedKey]
+	if !ok {
+		kv = &keyVersions{}
+		t.keys[hashedKey] = kv
+	}
+	kv.Key = key
+	kv.Current = storedVersion{Vector: kv.Current.Vector.Increment(s.ID), StorageKey: key, Size: size, WrittenAt: time.Now()}
+	if err := t.saveLocked(); err != nil {
+		slog.Warn("failed to save file versions", "error", err)
+	}
+}
+
+// storeReplica stores a file pushed by a peer according to how its version
+// relates to the local copy, and reports whether it was stored
+func (s *Server) storeReplica(from string, f *incomingFile) (int64, bool, error) {
+	t := s.versions
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	incoming := vclock.Vector(f.version)
+	hashedKey := f.key
+	kv := &keyVersions{Current: storedVersion{StorageKey: s.placement.storageKey(hashedKey)}}
+	if held, ok := t.keys[hashedKey]; ok {
+		// Changed on a copy so a failed write leaves the table as it was
+		kv = &keyVersions{Key: held.Key, Current: held.Current, Siblings: slices.Clone(held.Siblings)}
+	}
+	kv.Key = cmp.Or(kv.Key, f.userKey)
+	received := storedVersion{Vector: incoming, Size: int64(f.data.Len()), From: from, WrittenAt: time.Now()}
+
+	switch {
+	case !s.store.Has(kv.Current.StorageKey):
+		received.StorageKey = hashedKey
+		kv.Current = received
+	case kv.Current.Vector.Descends(incoming):
+		// Another node copied it first, or it is out of date
+		s.placement.keep(hashedKey, kv.Current.StorageKey)
+		return 0, false, nil
+	case incoming.Compare(kv.Current.Vector) == vclock.After:
+		if kv.Current.StorageKey != hashedKey && s.Retention != nil {
+			if err := s.Retention.Check(context.Background(), kv.Current.StorageKey, retention.OpOverwrite, s.ID); err != nil {
+				return 0, false, err
+			}
+		}
+		received.StorageKey = kv.Current.StorageKey
+		kv.Current = received
+	default:
+		for _, sib := range kv.Siblings {
+			if sib.Vector.Descends(incoming) {
+				return 0, false, nil
+			}
+		}
+		received.StorageKey = siblingKey(hashedKey, incoming)
+		kv.Siblings = append(kv.Siblings, received)
+	}
+
+	n, err := s.replaceContent(received.StorageKey, &f.data)
+	if err != nil {
+		return 0, false, err
+	}
+	conflicted := len(kv.Siblings) > 0
+	s.dropSupersededLocked(kv)
+	t.keys[hashedKey] = kv
+	if err := t.saveLocked(); err != nil {
+		slog.Warn("failed to save file versions", "error", err)
+	}
+	if received.StorageKey == kv.Current.StorageKey {
+		s.placement.hold(hashedKey, kv.Current.StorageKey)
+	} else {
+		s.placement.keep(hashedKey, kv.Current.StorageKey)
+	}
+
+	switch {
+	case received.StorageKey != kv.Current.StorageKey:
+		slog.Warn("conflicting version received", "key", hashedKey, "version", incoming.String(), "local", kv.Current.Vector.String(), "peer", from)
+		s.publishConflict(events.FileConflict, hashedKey, kv)
+	case conflicted && len(kv.Siblings) == 0:
+		s.publishConflict(events.FileConflictResolved, hashedKey, kv)
+	}
+	return n, true, nil
+}
+
+// replaceContent stores content under storageKey in place of what is stored
+// there, as the store only creates files
+func (s *Server) replaceContent(storageKey string, r io.Reader) (int64, error) {
+	if s.store.Has(storageKey) {
+		if err := s.deleteStored(storageKey); err != nil {
+			return 0, err
+		}
+	}
+	return s.writeEncrypted(storageKey, r)
+}
+
+// dropSupersededLocked deletes the conflicting versions that the current
+// version or a newer conflicting version descends from
+func (s *Server) dropSupersededLocked(kv *keyVersions) {
+	superseded := func(v vclock.Vector) bool {
+		if kv.Current.Vector.Descends(v) {
+			return true
+		}
+		return slices.ContainsFunc(kv.Siblings, func(sib storedVersion) bool { return sib.Vector.Compare(v) == vclock.After })
+	}
+	var kept []storedVersion
+	for _, sib := range kv.Siblings {
+		if !superseded(sib.Vector) {
+			kept = append(kept, sib)
+			continue
+		}
+		if err := s.deleteStored(sib.StorageKey); err != nil {
+			slog.Warn("failed to delete superseded version", "key", sib.StorageKey, "error", err)
+		}
+	}
+	kv.Siblings = kept
+}
+
+func (s *Server) publishConflict(eventType, hashedKey string, kv *keyVersions) {
+	c := kv.public(hashedKey)
+	s.Events.Publish(events.Event{Type: eventType, Key: cmp.Or(c.Key, hashedKey), Data: c})
+}
+
+// forgetVersions deletes the versions of a file conflicting with its copy
+// and forgets its version
+func (s *Server) forgetVersions(hashedKey string) {
+	t := s.versions
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	kv, ok := t.keys[hashedKey]
+	if !ok {
+		return
+	}
+	for _, sib := range kv.Siblings {
+		if err := s.deleteStored(sib.StorageKey); err != nil {
+			slog.Warn("failed to delete conflicting version", "key", sib.StorageKey, "error", err)
+		}
+	}
+	delete(t.keys, hashedKey)
+	if err := t.saveLocked(); err != nil {
+		slog.Warn("failed to save file versions", "error", err)
+	}
+}
+
+// lookupLocked finds a file by its key, or by its hashed key as listed by
+// Conflicts
+func (t *versionTable) lookupLocked(key string) (string, *keyVersions, bool) {
+	for _, hashedKey := range []string{crypto.HashKey(key), key} {
+		if kv, ok := t.keys[hashedKey]; ok {
+			return hashedKey, kv, true
+		}
+	}
+	return "", nil, false
+}
+
+// Conflicts returns the files this node holds concurrent versions of
+func (s *Server) Conflicts() []FileVersions {
+	t := s.versions
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var conflicts []FileVersions
+	for hashedKey, kv := range t.keys {
+		if len(kv.Siblings) > 0 {
+			conflicts = append(conflicts, kv.public(hashedKey))
+		}
+	}
+	slices.SortFunc(conflicts, func(a, b FileVersions) int { return cmp.Compare(a.HashedKey, b.HashedKey) })
+	return conflicts
+}
+
+// Versions returns the versions of a file this node holds, the current one
+// first. The key may be the file's key or its hashed key.
+func (s *Server) Versions(key string) (FileVersions, error) {
+	t := s.versions
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hashedKey, kv, ok := t.lookupLocked(key)
+	if !ok {
+		if storageKey, held := s.localKey(key); held {
+			// Stored before versions were tracked
+			kv = &keyVersions{Current: storedVersion{StorageKey: storageKey}}
+			if size, r, err := s.store.Read(storageKey); err == nil {
+				_ = r.Close()
+				kv.Current.Size = size
+			}
+			if storageKey == key {
+				kv.Key = key
+			}
+			return kv.public(crypto.HashKey(key)), nil
+		}
+		return FileVersions{}, ErrFileNotHeld
+	}
+	return kv.public(hashedKey), nil
+}
+
+// ReadVersion returns the content of one version of a file
+func (s *Server) ReadVersion(key, id string) (io.Reader, error) {
+	t := s.versions
+	t.mu.Lock()
+	_, kv, ok := t.lookupLocked(key)
+	if !ok {
+		t.mu.Unlock()
+		return nil, ErrFileNotHeld
+	}
+	v, ok := kv.version(id)
+	t.mu.Unlock()
+	if !ok {
+		return nil, ErrVersionNotFound
+	}
+
+	data, err := s.readDecrypted(v.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (kv *keyVersions) version(id string) (storedVersion, bool) {
+	for _, v := range append([]storedVersion{kv.Current}, kv.Siblings...) {
+		if v.Vector.ID() == id {
+			return v, true
+		}
+	}
+	return storedVersion{}, false
+}
+
+// ResolveConflict keeps version id of a file with concurrent versions and
+// discards the others. The kept content gets a version descending from all
+// of them, which replaces the copies of the file's owners.
+func (s *Server) ResolveConflict(ctx context.Context, key, id string) (FileVersion, error) {
+	return s.resolve(ctx, key, func(kv *keyVersions) (io.Reader, error) {
+		v, ok := kv.version(id)
+		if !ok {
+			return nil, ErrVersionNotFound
+		}
+		if v.StorageKey == kv.Current.StorageKey {
+			return nil, nil
+		}
+		data, err := s.readDecrypted(v.StorageKey)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(data), nil
+	})
+}
+
+// MergeConflict replaces the concurrent versions of a file with content
+// merged from them by the caller
+func (s *Server) MergeConflict(ctx context.Context, key string, r io.Reader) (FileVersion, error) {
+	return s.resolve(ctx, key, func(*keyVersions) (io.Reader, error) { return r, nil })
+}
+
+// resolve replaces the versions of a file with the content returned by
+// pick, or keeps the current content when pick returns none
+func (s *Server) resolve(ctx context.Context, key string, pick func(*keyVersions) (io.Reader, error)) (FileVersion, error) {
+	t := s.versions
+	t.mu.Lock()
+	hashedKey, kv, ok := t.lookupLocked(key)
+	if !ok {
+		t.mu.Unlock()
+		return FileVersion{}, ErrFileNotHeld
+	}
+	if len(kv.Siblings) == 0 {
+		t.mu.Unlock()
+		return FileVersion{}, ErrNoConflict
+	}
+	storageKey := kv.Current.StorageKey
+	if storageKey != hashedKey && s.Retention != nil {
+		if err := s.Retention.Check(ctx, storageKey, retention.OpOverwrite, s.ID); err != nil {
+			t.mu.Unlock()
+			return FileVersion{}, err
+		}
+	}
+	r, err := pick(kv)
+	if err != nil {
+		t.mu.Unlock()
+		return FileVersion{}, err
+	}
+	size := kv.Current.Size
+	if r != nil {
+		if size, err = s.replaceContent(storageKey, r); err != nil {
+			t.mu.Unlock()
+			return FileVersion{}, err
+		}
+	}
+	kv.Current = storedVersion{Vector: kv.merged().Increment(s.ID), StorageKey: storageKey, Size: size, WrittenAt: time.Now()}
+	s.dropSupersededLocked(kv)
+	if err := t.saveLocked(); err != nil {
+		slog.Warn("failed to save file versions", "error", err)
+	}
+	resolved := kv.Current.public(true)
+	s.publishConflict(events.FileConflictResolved, hashedKey, kv)
+	t.mu.Unlock()
+
+	s.placement.hold(hashedKey, storageKey)
+	s.replicate(ctx, hashedKey, storageKey, nil, nil)
+	return resolved, nil
+}
+
+// load reads the persisted versions, once
+func (t *versionTable) load() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.path == "" || t.loaded {
+		return nil
+	}
+	data, err := os.ReadFile(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		t.loaded = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	keys, _, err := parseVersionIndex(data)
+	if err != nil {
+		return fmt.Errorf("corrupt version store %s: %w", t.path, err)
+	}
+	t.keys = keys
+	if t.keys == nil {
+		t.keys = make(map[string]*keyVersions)
+	}
+	t.loaded = true
+	return nil
+}
+
+// parseVersionIndex parses a persisted version table of any format,
+// returning its keys and format
+func parseVersionIndex(data []byte) (map[string]*keyVersions, int, error) {
+	var index struct {
+		Format int             `json:"format"`
+		Keys   json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, 0, err
+	}
+	var keys map[string]*keyVersions
+	switch {
+	case index.Format == 0:
+		// Keys are hashed, so none is named format
+		err := json.Unmarshal(data, &keys)
+		return keys, 0, err
+	case index.Format > versionIndexFormat:
+		return nil, index.Format, fmt.Errorf("format %d is newer than this release supports", index.Format)
+	default:
+		err := json.Unmarshal(index.Keys, &keys)
+		return keys, index.Format, err
+	}
+}
+
+func (t *versionTable) saveLocked() error {
+	if t.path == "" {
+		return nil
+	}
+	return writeVersionIndex(t.path, t.keys)
+}
+
+func writeVersionIndex(path string, keys map[string]*keyVersions) error {
+	data, err := json.Marshal(versionIndex{Format: versionIndexFormat, Keys: keys})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// upgradeFormat rewrites a persisted version table written before its
+// format was recorded in the current format
+func (t *versionTable) upgradeFormat(dryRun bool) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys, format, err := parseVersionIndex(data)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt version store %s: %w", t.path, err)
+	}
+	if format == versionIndexFormat {
+		return nil, nil
+	}
+	change := fmt.Sprintf("rewrite %s in format %d, with the versions of %d files", t.path, versionIndexFormat, len(keys))
+	if dryRun {
+		return []string{change}, nil
+	}
+	if err := writeVersionIndex(t.path, keys); err != nil {
+		return nil, err
+	}
+	return []string{change}, nil
+}
@@ -0,0 +1,21 @@
+package fileserver
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/watch"
+)
+
+// Watch returns the changes to files under prefix made through this node
+// after token, then those made from now on, until ctx is done. Copies
+// pushed by peers are not reported: they are stored under hashed keys.
+// See package watch for resuming and expired tokens.
+func (s *Server) Watch(ctx context.Context, prefix, token string) (<-chan watch.Event, error) {
+	return s.changes.Watch(ctx, prefix, token)
+}
+
+// WatchToken returns the token of the last change made through this node,
+// to watch from after listing the files
+func (s *Server) WatchToken() string {
+	return s.changes.Token()
+}
@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 	"sync"
 	"time"
+
+	"github.com/Skpow1234/Peervault/internal/requestid"
 )
 
 // AuditLevel represents the level of an audit event
@@ -42,6 +44,7 @@ type AuditEvent struct {
 	SessionID string                 `json:"session_id,omitempty"`
 	IPAddress string                 `json:"ip_address,omitempty"`
 	UserAgent string                 `json:"user_agent,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
 	Resource  string                 `json:"resource,omitempty"`
 	Action    string                 `json:"action,omitempty"`
 	Result    string                 `json:"result,omitempty"` // success, failure, denied
@@ -108,6 +111,9 @@ func (al *AuditLogger) LogEvent(ctx context.Context, event *AuditEvent) error {
 	if event.Level == "" {
 		event.Level = AuditLevelInfo
 	}
+	if event.RequestID == "" {
+		event.RequestID = requestid.FromContext(ctx)
+	}
 
 	// Add to buffer
 	al.bufferMu.Lock()
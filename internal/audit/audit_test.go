@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/Skpow1234/Peervault/internal/requestid"
 )
 
 func TestAuditConstants(t *testing.T) {
@@ -63,6 +65,23 @@ func TestAuditEvent(t *testing.T) {
 	assert.Len(t, event.Tags, 3)
 }
 
+func TestAuditLogger_LogEventRequestID(t *testing.T) {
+	logger, err := NewAuditLogger(filepath.Join(t.TempDir(), "audit.log"))
+	assert.NoError(t, err)
+	defer func() { _ = logger.Close() }()
+
+	ctx := requestid.NewContext(context.Background(), "trace-9")
+	assert.NoError(t, logger.LogEvent(ctx, &AuditEvent{Message: "from a request"}))
+	assert.NoError(t, logger.LogEvent(ctx, &AuditEvent{Message: "own ID", RequestID: "trace-10"}))
+	assert.NoError(t, logger.LogEvent(context.Background(), &AuditEvent{Message: "no request"}))
+
+	events := logger.GetEvents(nil)
+	assert.Len(t, events, 3)
+	assert.Equal(t, "trace-9", events[0].RequestID)
+	assert.Equal(t, "trace-10", events[1].RequestID)
+	assert.Empty(t, events[2].RequestID)
+}
+
 func TestNewAuditLogger(t *testing.T) {
 	// Create a temporary directory for testing
 	tempDir := t.TempDir()
@@ -0,0 +1,238 @@
+// Package autotls provides the TLS settings every HTTP-facing server of a
+// node shares: a certificate read from files, reloaded when the files
+// change, or certificates obtained and renewed automatically over ACME, e.g.
+// from Let's Encrypt, so small deployments get HTTPS without a reverse
+// proxy in front.
+package autotls
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Config configures TLS. Either CertFile and KeyFile or ACME must be set.
+type Config struct {
+	// CertFile and KeyFile hold a PEM certificate chain and its key
+	CertFile string
+	KeyFile  string
+	// ACME obtains certificates automatically instead
+	ACME *ACMEConfig
+}
+
+// ACMEConfig configures automatic certificates
+type ACMEConfig struct {
+	// Domains certificates are obtained for; connections naming other
+	// hosts are refused
+	Domains []string
+	// Email is given to the CA for expiry and account notices
+	Email string
+	// CacheDir keeps the account key and certificates across restarts,
+	// so they are not requested again
+	CacheDir string
+	// DirectoryURL is the ACME directory of the CA; empty uses
+	// Let's Encrypt
+	DirectoryURL string
+	// RenewBefore is how long before expiry certificates are renewed;
+	// 0 means 30 days
+	RenewBefore time.Duration
+}
+
+// TLS hands out the TLS settings of the servers
+type TLS struct {
+	config  *tls.Config
+	manager *autocert.Manager
+}
+
+// New loads the certificate of config, or prepares to obtain them over
+// ACME. ACME answers TLS-ALPN-01 challenges on the servers' own ports;
+// serving HTTPHandler on port 80 adds HTTP-01.
+func New(config *Config) (*TLS, error) {
+	if config.ACME != nil {
+		return newACME(config.ACME)
+	}
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, errors.New("autotls: a certificate and key file, or ACME, are required")
+	}
+	reloader := &certReloader{certFile: config.CertFile, keyFile: config.KeyFile}
+	if _, err := reloader.load(); err != nil {
+		return nil, err
+	}
+	return &TLS{config: &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: reloader.GetCertificate,
+	}}, nil
+}
+
+func newACME(config *ACMEConfig) (*TLS, error) {
+	if len(config.Domains) == 0 {
+		return nil, errors.New("autotls: ACME needs at least one domain")
+	}
+	if config.CacheDir == "" {
+		return nil, errors.New("autotls: ACME needs a cache directory")
+	}
+	if err := os.MkdirAll(config.CacheDir, 0o700); err != nil {
+		return nil, fmt.Errorf("autotls: failed to create ACME cache: %w", err)
+	}
+	manager := &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(config.CacheDir),
+		HostPolicy:  autocert.HostWhitelist(config.Domains...),
+		Email:       config.Email,
+		RenewBefore: config.RenewBefore,
+	}
+	if config.DirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: config.DirectoryURL}
+	}
+	tlsConfig := manager.TLSConfig()
+	tlsConfig.MinVersion = tls.VersionTLS12
+	return &TLS{config: tlsConfig, manager: manager}, nil
+}
+
+// Flags are the TLS command line flags of the standalone API servers
+type Flags struct {
+	CertFile, KeyFile string
+	Domains           string
+	Email             string
+	CacheDir          string
+}
+
+// RegisterFlags adds the -tls-cert, -tls-key, -acme-domains, -acme-email
+// and -acme-cache flags to fs
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.CertFile, "tls-cert", "", "PEM certificate file to serve HTTPS with")
+	fs.StringVar(&f.KeyFile, "tls-key", "", "PEM key file of -tls-cert")
+	fs.StringVar(&f.Domains, "acme-domains", "", "Comma-separated domains to obtain certificates for from Let's Encrypt instead")
+	fs.StringVar(&f.Email, "acme-email", "", "Contact email for the ACME account")
+	fs.StringVar(&f.CacheDir, "acme-cache", "acme-cache", "Directory keeping ACME certificates across restarts")
+	return f
+}
+
+// Load returns the TLS settings the flags ask for; nil when no flag turns
+// TLS on
+func (f *Flags) Load() (*TLS, error) {
+	if f.Domains != "" {
+		return New(&Config{ACME: &ACMEConfig{
+			Domains:  strings.Split(f.Domains, ","),
+			Email:    f.Email,
+			CacheDir: f.CacheDir,
+		}})
+	}
+	if f.CertFile == "" && f.KeyFile == "" {
+		return nil, nil
+	}
+	return New(&Config{CertFile: f.CertFile, KeyFile: f.KeyFile})
+}
+
+// Config returns the TLS settings for one server; nil on a nil TLS, which
+// stands for plain HTTP
+func (t *TLS) Config() *tls.Config {
+	if t == nil {
+		return nil
+	}
+	return t.config.Clone()
+}
+
+// ACME reports whether certificates are obtained over ACME
+func (t *TLS) ACME() bool {
+	return t.manager != nil
+}
+
+// HTTPHandler serves plain HTTP next to the TLS servers, usually on port
+// 80: it answers ACME HTTP-01 challenges and redirects everything else to
+// HTTPS
+func (t *TLS) HTTPHandler() http.Handler {
+	if t.manager == nil {
+		return http.HandlerFunc(redirect)
+	}
+	return t.manager.HTTPHandler(nil)
+}
+
+// Prefetch obtains the certificates of the ACME domains up front, so the
+// first clients do not wait for them
+func (t *TLS) Prefetch(ctx context.Context, domains ...string) error {
+	if t.manager == nil {
+		return nil
+	}
+	for _, domain := range domains {
+		if _, err := t.manager.GetCertificate(&tls.ClientHelloInfo{ServerName: domain}); err != nil {
+			return fmt.Errorf("autotls: failed to obtain certificate for %s: %w", domain, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redirect sends plain HTTP requests to HTTPS
+func redirect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Use HTTPS", http.StatusBadRequest)
+		return
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusFound)
+}
+
+// certReloader serves a certificate from files, loading it again when the
+// files change, e.g. after an external renewal
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.load()
+}
+
+func (c *certReloader) load() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	modTime := latestModTime(c.certFile, c.keyFile)
+	if c.cert != nil && !modTime.After(c.modTime) {
+		return c.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		if c.cert != nil {
+			// Keep serving the last good certificate while the files are
+			// half written
+			return c.cert, nil
+		}
+		return nil, fmt.Errorf("autotls: failed to load certificate: %w", err)
+	}
+	c.cert, c.modTime = &cert, modTime
+	return c.cert, nil
+}
+
+func latestModTime(files ...string) time.Time {
+	var latest time.Time
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
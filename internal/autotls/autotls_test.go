@@ -0,0 +1,129 @@
+package autotls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes a self-signed certificate for commonName
+func writeCert(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
+
+func TestStaticCertificates(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "one.example.com")
+
+	certs, err := New(&Config{CertFile: certFile, KeyFile: keyFile})
+	require.NoError(t, err)
+	assert.False(t, certs.ACME())
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	srv.TLS = certs.Config()
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	// httptest serves its own certificate to clients sending no SNI
+	serverName := func() string {
+		conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, ServerName: "vault.example.com"})
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	assert.Equal(t, "one.example.com", serverName())
+
+	// A renewed certificate is picked up without a restart
+	writeCert(t, certFile, keyFile, "two.example.com")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	assert.Equal(t, "two.example.com", serverName())
+
+	// A broken file keeps the last good certificate in use
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0o600))
+	later := future.Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	assert.Equal(t, "two.example.com", serverName())
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(&Config{})
+	assert.Error(t, err)
+
+	_, err = New(&Config{CertFile: "/nonexistent/cert.pem", KeyFile: "/nonexistent/key.pem"})
+	assert.Error(t, err)
+
+	_, err = New(&Config{ACME: &ACMEConfig{CacheDir: t.TempDir()}})
+	assert.Error(t, err)
+}
+
+func TestACME(t *testing.T) {
+	certs, err := New(&Config{ACME: &ACMEConfig{
+		Domains:  []string{"vault.example.com"},
+		CacheDir: filepath.Join(t.TempDir(), "acme"),
+		// Nothing listens here; no test must reach the CA
+		DirectoryURL: "http://127.0.0.1:1/directory",
+	}})
+	require.NoError(t, err)
+	assert.True(t, certs.ACME())
+
+	config := certs.Config()
+	assert.Contains(t, config.NextProtos, "acme-tls/1")
+	assert.Contains(t, config.NextProtos, "h2")
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+
+	// Hosts outside the domains never trigger an order
+	_, err = config.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+	assert.ErrorContains(t, err, "not configured")
+
+	// Plain HTTP is redirected to HTTPS
+	rec := httptest.NewRecorder()
+	certs.HTTPHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://vault.example.com/files?x=1", nil))
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "https://vault.example.com/files?x=1", rec.Header().Get("Location"))
+}
+
+func TestRedirect(t *testing.T) {
+	certs := &TLS{}
+
+	rec := httptest.NewRecorder()
+	certs.HTTPHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://vault.example.com:80/health", nil))
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "https://vault.example.com/health", rec.Header().Get("Location"))
+
+	rec = httptest.NewRecorder()
+	certs.HTTPHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://vault.example.com/files", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,12 @@
+package backup
+
+import "github.com/Skpow1234/Peervault/internal/scheduler"
+
+// Schedule is a parsed cron expression
+type Schedule = scheduler.Cron
+
+// ParseSchedule parses a cron expression such as "30 2 * * *" or "@daily",
+// see scheduler.ParseCron
+func ParseSchedule(expr string) (*Schedule, error) {
+	return scheduler.ParseCron(expr)
+}
@@ -0,0 +1,558 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrJobRunning       = errors.New("backup: job is already running")
+	ErrSnapshotNotFound = errors.New("backup: snapshot not found")
+	// ErrSnapshotInUse is returned when deleting a snapshot whose blobs are
+	// still referenced by later incremental snapshots
+	ErrSnapshotInUse = errors.New("backup: snapshot is referenced by later snapshots")
+	// ErrNoSnapshot is returned when no snapshot matches a restore point
+	ErrNoSnapshot = errors.New("backup: no snapshot at or before the requested time")
+	// ErrExists is returned by a Sink when a key exists and overwriting was
+	// not requested
+	ErrExists = errors.New("backup: key already exists")
+)
+
+// Entry describes one object in a snapshot
+type Entry struct {
+	Key         string `json:"key"`
+	Name        string `json:"name,omitempty"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type,omitempty"`
+	// Hash is the content hash reported by the source, used to detect changes
+	Hash     string            `json:"hash,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	ModTime  time.Time         `json:"mod_time"`
+	// Checksum is the SHA-256 of the stored blob, checked on verify and restore
+	Checksum string `json:"checksum,omitempty"`
+	// Snapshot is the snapshot whose blob area holds the content; entries
+	// unchanged since the parent point at an earlier snapshot
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// Source lists and reads the objects of a namespace
+type Source interface {
+	// List returns the objects whose keys start with prefix
+	List(ctx context.Context, prefix string) ([]Entry, error)
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// Sink writes restored objects back at their original keys
+type Sink interface {
+	// Restore writes an object; it returns ErrExists if the key exists and
+	// overwrite is false
+	Restore(ctx context.Context, entry Entry, r io.Reader, overwrite bool) error
+}
+
+// Snapshot is a point-in-time copy of a namespace. Every snapshot lists the
+// complete namespace state, so restoring never needs to replay a chain;
+// incremental snapshots only upload the objects that changed.
+type Snapshot struct {
+	ID          string       `json:"id"`
+	Job         string       `json:"job"`
+	Namespace   string       `json:"namespace"`
+	Type        BackupType   `json:"type"`
+	Parent      string       `json:"parent,omitempty"`
+	Status      BackupStatus `json:"status"`
+	StartedAt   time.Time    `json:"started_at"`
+	CompletedAt time.Time    `json:"completed_at"`
+	// Files and Size cover the whole namespace; Changed and Uploaded only
+	// what this snapshot had to store
+	Files    int     `json:"files"`
+	Size     int64   `json:"size"`
+	Changed  int     `json:"changed"`
+	Uploaded int64   `json:"uploaded"`
+	Entries  []Entry `json:"entries,omitempty"`
+}
+
+// RestoreOptions selects what to restore. With no Snapshot, the latest
+// snapshot completed at or before At is used (the latest overall if At is zero).
+type RestoreOptions struct {
+	Snapshot  string
+	At        time.Time
+	Prefix    string
+	Overwrite bool
+}
+
+// RestoreReport summarises a restore
+type RestoreReport struct {
+	Snapshot string    `json:"snapshot"`
+	Restored int       `json:"restored"`
+	Skipped  int       `json:"skipped"`
+	Failed   int       `json:"failed"`
+	Bytes    int64     `json:"bytes"`
+	Errors   []string  `json:"errors,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// VerifyReport lists the blobs of a snapshot that are missing or do not
+// match their recorded checksum
+type VerifyReport struct {
+	Snapshot   string    `json:"snapshot"`
+	Checked    int       `json:"checked"`
+	Bytes      int64     `json:"bytes"`
+	Missing    []string  `json:"missing,omitempty"`
+	Corrupt    []string  `json:"corrupt,omitempty"`
+	Healthy    bool      `json:"healthy"`
+	VerifiedAt time.Time `json:"verified_at"`
+}
+
+// Engine takes, verifies and restores snapshots of a source
+type Engine struct {
+	source Source
+	sink   Sink
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+// NewEngine creates an engine that backs up source and restores into sink
+func NewEngine(source Source, sink Sink, logger *slog.Logger) *Engine {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Engine{source: source, sink: sink, logger: logger, running: make(map[string]bool)}
+}
+
+// Running reports whether a backup of the job is in progress
+func (e *Engine) Running(job string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.running[job]
+}
+
+// Backup takes a snapshot of the job's namespace. Incremental backups fall
+// back to a full one when the job has no snapshot yet.
+func (e *Engine) Backup(ctx context.Context, job *Job, typ BackupType) (*Snapshot, error) {
+	return e.BackupFrom(ctx, job, typ, e.source)
+}
+
+// BackupFrom backs up the job's namespace as source presents it, e.g. a
+// point-in-time snapshot instead of the live files
+func (e *Engine) BackupFrom(ctx context.Context, job *Job, typ BackupType, source Source) (*Snapshot, error) {
+	e.mu.Lock()
+	if e.running[job.Name] {
+		e.mu.Unlock()
+		return nil, ErrJobRunning
+	}
+	e.running[job.Name] = true
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		delete(e.running, job.Name)
+		e.mu.Unlock()
+	}()
+
+	if typ != BackupTypeFull && typ != BackupTypeIncremental {
+		return nil, fmt.Errorf("backup: unsupported backup type %q", typ)
+	}
+
+	var parent *Snapshot
+	if typ == BackupTypeIncremental {
+		snaps, err := e.Snapshots(ctx, job)
+		if err != nil {
+			return nil, err
+		}
+		if len(snaps) == 0 {
+			typ = BackupTypeFull
+		} else if parent, err = e.Snapshot(ctx, job, snaps[len(snaps)-1].ID); err != nil {
+			return nil, err
+		}
+	}
+
+	snap := &Snapshot{
+		ID:        newSnapshotID(time.Now()),
+		Job:       job.Name,
+		Namespace: job.Namespace,
+		Type:      typ,
+		Status:    BackupStatusRunning,
+		StartedAt: time.Now().UTC(),
+	}
+	previous := make(map[string]Entry)
+	if parent != nil {
+		snap.Parent = parent.ID
+		for _, entry := range parent.Entries {
+			previous[entry.Key] = entry
+		}
+	}
+
+	entries, err := source.List(ctx, job.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("backup: listing %q: %w", job.Namespace, err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+
+	var uploaded []string
+	cleanup := func() {
+		// Best effort: a snapshot without a manifest is invisible anyway
+		for _, p := range uploaded {
+			_ = job.target.Delete(context.WithoutCancel(ctx), p)
+		}
+	}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			cleanup()
+			return nil, err
+		}
+		if prev, ok := previous[entry.Key]; ok && unchanged(prev, entry) {
+			entry.Checksum, entry.Snapshot = prev.Checksum, prev.Snapshot
+		} else {
+			p := blobPath(job.Name, snap.ID, entry.Key)
+			uploaded = append(uploaded, p)
+			n, sum, err := e.upload(ctx, job, source, entry, p)
+			if err != nil {
+				cleanup()
+				return nil, fmt.Errorf("backup: %s: %w", entry.Key, err)
+			}
+			entry.Size, entry.Checksum, entry.Snapshot = n, sum, snap.ID
+			snap.Changed++
+			snap.Uploaded += n
+		}
+		snap.Entries = append(snap.Entries, entry)
+		snap.Files++
+		snap.Size += entry.Size
+	}
+
+	snap.Status = BackupStatusCompleted
+	snap.CompletedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	// The manifest goes last: a snapshot only exists once all its blobs do
+	if err := job.target.Put(ctx, manifestPath(job.Name, snap.ID), bytes.NewReader(data), int64(len(data))); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("backup: writing manifest: %w", err)
+	}
+
+	e.logger.Info("Backup completed", "job", job.Name, "snapshot", snap.ID, "type", snap.Type,
+		"files", snap.Files, "changed", snap.Changed, "uploaded", snap.Uploaded)
+
+	if job.Keep > 0 {
+		if err := e.prune(ctx, job); err != nil {
+			e.logger.Warn("Failed to prune old snapshots", "job", job.Name, "error", err)
+		}
+	}
+	return snap, nil
+}
+
+// unchanged reports whether the source object still matches the previous
+// snapshot's copy. Content hashes win when the source reports them, so
+// attribute-only updates do not re-upload content.
+func unchanged(prev, cur Entry) bool {
+	if prev.Size != cur.Size {
+		return false
+	}
+	if cur.Hash != "" && prev.Hash != "" {
+		return cur.Hash == prev.Hash
+	}
+	return prev.ModTime.Equal(cur.ModTime)
+}
+
+func (e *Engine) upload(ctx context.Context, job *Job, source Source, entry Entry, p string) (int64, string, error) {
+	rc, err := source.Open(ctx, entry.Key)
+	if err != nil {
+		return 0, "", err
+	}
+	defer rc.Close()
+
+	cr := &checksumReader{r: rc, h: sha256.New()}
+	if err := job.target.Put(ctx, p, cr, entry.Size); err != nil {
+		return 0, "", err
+	}
+	if cr.n != entry.Size {
+		return 0, "", fmt.Errorf("size changed during backup (%d != %d)", cr.n, entry.Size)
+	}
+	return cr.n, hex.EncodeToString(cr.h.Sum(nil)), nil
+}
+
+// Snapshots lists the job's completed snapshots, oldest first, without
+// their entries
+func (e *Engine) Snapshots(ctx context.Context, job *Job) ([]Snapshot, error) {
+	paths, err := job.target.List(ctx, job.Name+"/snapshots/")
+	if err != nil {
+		return nil, err
+	}
+	snaps := make([]Snapshot, 0, len(paths))
+	for _, p := range paths {
+		id, ok := strings.CutSuffix(strings.TrimPrefix(p, job.Name+"/snapshots/"), ".json")
+		if !ok || strings.Contains(id, "/") {
+			continue
+		}
+		snap, err := e.Snapshot(ctx, job, id)
+		if err != nil {
+			return nil, err
+		}
+		snap.Entries = nil
+		snaps = append(snaps, *snap)
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].StartedAt.Before(snaps[j].StartedAt) })
+	return snaps, nil
+}
+
+// Snapshot loads a snapshot manifest including its entries
+func (e *Engine) Snapshot(ctx context.Context, job *Job, id string) (*Snapshot, error) {
+	if id == "" || strings.ContainsAny(id, "/\\") || strings.Contains(id, "..") {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, id)
+	}
+	rc, err := job.target.Get(ctx, manifestPath(job.Name, id))
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var snap Snapshot
+	if err := json.NewDecoder(rc).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("backup: corrupt manifest for snapshot %s: %w", id, err)
+	}
+	return &snap, nil
+}
+
+// Verify reads back every blob of a snapshot and checks it against the
+// recorded checksum
+func (e *Engine) Verify(ctx context.Context, job *Job, id string) (*VerifyReport, error) {
+	snap, err := e.Snapshot(ctx, job, id)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &VerifyReport{Snapshot: id}
+	for _, entry := range snap.Entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		report.Checked++
+		rc, err := job.target.Get(ctx, blobPath(job.Name, entry.Snapshot, entry.Key))
+		if errors.Is(err, ErrObjectNotFound) {
+			report.Missing = append(report.Missing, entry.Key)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		report.Bytes += n
+		if n != entry.Size || hex.EncodeToString(h.Sum(nil)) != entry.Checksum {
+			report.Corrupt = append(report.Corrupt, entry.Key)
+		}
+	}
+	report.Healthy = len(report.Missing) == 0 && len(report.Corrupt) == 0
+	report.VerifiedAt = time.Now().UTC()
+
+	level := slog.LevelInfo
+	if !report.Healthy {
+		level = slog.LevelWarn
+	}
+	e.logger.Log(ctx, level, "Backup verified", "job", job.Name, "snapshot", id,
+		"checked", report.Checked, "missing", len(report.Missing), "corrupt", len(report.Corrupt))
+	return report, nil
+}
+
+// Restore writes the objects of a snapshot back to the sink. Blobs are
+// checked against their checksum while streaming, so a corrupt copy is
+// never written.
+func (e *Engine) Restore(ctx context.Context, job *Job, opts RestoreOptions) (*RestoreReport, error) {
+	id := opts.Snapshot
+	if id == "" {
+		snaps, err := e.Snapshots(ctx, job)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range snaps {
+			if opts.At.IsZero() || !s.CompletedAt.After(opts.At) {
+				id = s.ID
+			}
+		}
+		if id == "" {
+			return nil, ErrNoSnapshot
+		}
+	}
+	snap, err := e.Snapshot(ctx, job, id)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RestoreReport{Snapshot: id, Started: time.Now().UTC()}
+	for _, entry := range snap.Entries {
+		if !strings.HasPrefix(entry.Key, opts.Prefix) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		n, err := e.restoreEntry(ctx, job, entry, opts.Overwrite)
+		switch {
+		case errors.Is(err, ErrExists):
+			report.Skipped++
+		case err != nil:
+			report.Failed++
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", entry.Key, err))
+		default:
+			report.Restored++
+			report.Bytes += n
+		}
+	}
+	report.Finished = time.Now().UTC()
+
+	e.logger.Info("Restore completed", "job", job.Name, "snapshot", id,
+		"restored", report.Restored, "skipped", report.Skipped, "failed", report.Failed)
+	return report, nil
+}
+
+func (e *Engine) restoreEntry(ctx context.Context, job *Job, entry Entry, overwrite bool) (int64, error) {
+	rc, err := job.target.Get(ctx, blobPath(job.Name, entry.Snapshot, entry.Key))
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	cr := &checksumReader{r: rc, h: sha256.New(), want: entry.Checksum, size: entry.Size}
+	if err := e.sink.Restore(ctx, entry, cr, overwrite); err != nil {
+		return 0, err
+	}
+	return cr.n, nil
+}
+
+// Delete removes a snapshot and its blobs. Snapshots whose blobs later
+// incrementals still reference are refused with ErrSnapshotInUse.
+func (e *Engine) Delete(ctx context.Context, job *Job, id string) error {
+	if _, err := e.Snapshot(ctx, job, id); err != nil {
+		return err
+	}
+	snaps, err := e.Snapshots(ctx, job)
+	if err != nil {
+		return err
+	}
+	for _, s := range snaps {
+		if s.ID == id {
+			continue
+		}
+		full, err := e.Snapshot(ctx, job, s.ID)
+		if err != nil {
+			return err
+		}
+		for _, entry := range full.Entries {
+			if entry.Snapshot == id {
+				return fmt.Errorf("%w: %s is used by %s", ErrSnapshotInUse, id, s.ID)
+			}
+		}
+	}
+	return e.deleteSnapshot(ctx, job, id)
+}
+
+// prune keeps the job's newest Keep full snapshots and the incrementals
+// taken after the oldest of them. Everything older only references blobs
+// of snapshots that are removed together with it.
+func (e *Engine) prune(ctx context.Context, job *Job) error {
+	snaps, err := e.Snapshots(ctx, job)
+	if err != nil {
+		return err
+	}
+	var fulls []Snapshot
+	for _, s := range snaps {
+		if s.Type == BackupTypeFull {
+			fulls = append(fulls, s)
+		}
+	}
+	if len(fulls) <= job.Keep {
+		return nil
+	}
+	cutoff := fulls[len(fulls)-job.Keep].StartedAt
+
+	// Newest first so no remaining manifest points at a deleted blob
+	for i := len(snaps) - 1; i >= 0; i-- {
+		if snaps[i].StartedAt.Before(cutoff) {
+			if err := e.deleteSnapshot(ctx, job, snaps[i].ID); err != nil {
+				return err
+			}
+			e.logger.Info("Pruned backup snapshot", "job", job.Name, "snapshot", snaps[i].ID)
+		}
+	}
+	return nil
+}
+
+func (e *Engine) deleteSnapshot(ctx context.Context, job *Job, id string) error {
+	if err := job.target.Delete(ctx, manifestPath(job.Name, id)); err != nil {
+		return err
+	}
+	blobs, err := job.target.List(ctx, job.Name+"/blobs/"+id+"/")
+	if err != nil {
+		return err
+	}
+	for _, p := range blobs {
+		if err := job.target.Delete(ctx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func manifestPath(job, id string) string {
+	return job + "/snapshots/" + id + ".json"
+}
+
+// blobPath names blobs by a hash of their key so arbitrary keys map to
+// safe, flat paths on every target
+func blobPath(job, id, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return job + "/blobs/" + id + "/" + hex.EncodeToString(sum[:])
+}
+
+// newSnapshotID returns a time-ordered, unique snapshot ID
+func newSnapshotID(now time.Time) string {
+	var suffix [3]byte
+	_, _ = rand.Read(suffix[:])
+	return now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix[:])
+}
+
+// checksumReader hashes and counts what passes through it. When want is
+// set it fails at EOF if the content does not match, so consumers reading
+// to the end never accept a corrupt blob.
+type checksumReader struct {
+	r    io.Reader
+	h    hash.Hash
+	n    int64
+	want string
+	size int64
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.h.Write(p[:n])
+	c.n += int64(n)
+	if err == io.EOF && c.want != "" {
+		if c.n != c.size || hex.EncodeToString(c.h.Sum(nil)) != c.want {
+			return n, errors.New("backup: blob does not match its checksum")
+		}
+	}
+	return n, err
+}
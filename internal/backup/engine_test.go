@@ -0,0 +1,494 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skpow1234/Peervault/internal/scheduler"
+	"github.com/Skpow1234/Peervault/pkg/plugin"
+)
+
+// memStore is an in-memory Source and Sink
+type memStore struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	mod   map[string]time.Time
+}
+
+func newMemStore() *memStore {
+	return &memStore{files: make(map[string][]byte), mod: make(map[string]time.Time)}
+}
+
+func (m *memStore) put(key, content string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[key] = []byte(content)
+	m.mod[key] = time.Now()
+}
+
+func (m *memStore) get(key string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[key]
+	return string(data), ok
+}
+
+func (m *memStore) remove(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, key)
+}
+
+func (m *memStore) List(ctx context.Context, prefix string) ([]Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []Entry
+	for key, data := range m.files {
+		if strings.HasPrefix(key, prefix) {
+			entries = append(entries, Entry{Key: key, Size: int64(len(data)), ModTime: m.mod[key]})
+		}
+	}
+	return entries, nil
+}
+
+func (m *memStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return io.NopCloser(bytes.NewReader(m.files[key])), nil
+}
+
+func (m *memStore) Restore(ctx context.Context, entry Entry, r io.Reader, overwrite bool) error {
+	if _, ok := m.get(entry.Key); ok && !overwrite {
+		return ErrExists
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.put(entry.Key, string(data))
+	return nil
+}
+
+func newTestJob(t *testing.T) (*Job, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := &Config{Jobs: []*Job{{
+		Name:      "docs",
+		Namespace: "docs/",
+		Target:    TargetConfig{Type: "dir", Path: dir},
+	}}}
+	require.NoError(t, cfg.Validate())
+	return cfg.Jobs[0], dir
+}
+
+func TestEngine_FullAndIncremental(t *testing.T) {
+	store := newMemStore()
+	store.put("docs/a.txt", "alpha")
+	store.put("docs/b.txt", "bravo")
+	store.put("other/c.txt", "not in namespace")
+
+	job, _ := newTestJob(t)
+	engine := NewEngine(store, store, nil)
+	ctx := context.Background()
+
+	// The first incremental becomes a full backup
+	full, err := engine.Backup(ctx, job, BackupTypeIncremental)
+	require.NoError(t, err)
+	assert.Equal(t, BackupTypeFull, full.Type)
+	assert.Equal(t, 2, full.Files)
+	assert.Equal(t, 2, full.Changed)
+	assert.Equal(t, int64(10), full.Uploaded)
+
+	store.put("docs/b.txt", "bravo two")
+	store.put("docs/d.txt", "delta")
+	store.remove("docs/a.txt")
+
+	incr, err := engine.Backup(ctx, job, BackupTypeIncremental)
+	require.NoError(t, err)
+	assert.Equal(t, BackupTypeIncremental, incr.Type)
+	assert.Equal(t, full.ID, incr.Parent)
+	assert.Equal(t, 2, incr.Files)
+	assert.Equal(t, 2, incr.Changed)
+
+	store.put("docs/e.txt", "echo")
+	incr2, err := engine.Backup(ctx, job, BackupTypeIncremental)
+	require.NoError(t, err)
+	assert.Equal(t, 1, incr2.Changed)
+
+	loaded, err := engine.Snapshot(ctx, job, incr2.ID)
+	require.NoError(t, err)
+	owners := map[string]string{}
+	for _, e := range loaded.Entries {
+		owners[e.Key] = e.Snapshot
+	}
+	assert.Equal(t, map[string]string{"docs/b.txt": incr.ID, "docs/d.txt": incr.ID, "docs/e.txt": incr2.ID}, owners)
+
+	snaps, err := engine.Snapshots(ctx, job)
+	require.NoError(t, err)
+	require.Len(t, snaps, 3)
+	assert.Nil(t, snaps[0].Entries)
+}
+
+func TestEngine_RestorePointInTime(t *testing.T) {
+	store := newMemStore()
+	store.put("docs/a.txt", "v1")
+	job, _ := newTestJob(t)
+	engine := NewEngine(store, store, nil)
+	ctx := context.Background()
+
+	first, err := engine.Backup(ctx, job, BackupTypeFull)
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	store.put("docs/a.txt", "v2")
+	store.put("docs/b.txt", "new")
+	_, err = engine.Backup(ctx, job, BackupTypeIncremental)
+	require.NoError(t, err)
+
+	// Existing keys are skipped unless overwriting
+	report, err := engine.Restore(ctx, job, RestoreOptions{At: first.CompletedAt})
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, report.Snapshot)
+	assert.Equal(t, 1, report.Skipped)
+
+	report, err = engine.Restore(ctx, job, RestoreOptions{At: first.CompletedAt, Overwrite: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Restored)
+	got, _ := store.get("docs/a.txt")
+	assert.Equal(t, "v1", got)
+
+	// The latest snapshot is the default restore point
+	store.remove("docs/b.txt")
+	report, err = engine.Restore(ctx, job, RestoreOptions{Prefix: "docs/b"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Restored)
+	got, _ = store.get("docs/b.txt")
+	assert.Equal(t, "new", got)
+
+	_, err = engine.Restore(ctx, job, RestoreOptions{At: first.StartedAt.Add(-time.Hour)})
+	assert.ErrorIs(t, err, ErrNoSnapshot)
+}
+
+func TestEngine_VerifyDetectsDamage(t *testing.T) {
+	store := newMemStore()
+	store.put("docs/a.txt", "alpha")
+	store.put("docs/b.txt", "bravo")
+	job, dir := newTestJob(t)
+	engine := NewEngine(store, store, nil)
+	ctx := context.Background()
+
+	snap, err := engine.Backup(ctx, job, BackupTypeFull)
+	require.NoError(t, err)
+
+	report, err := engine.Verify(ctx, job, snap.ID)
+	require.NoError(t, err)
+	assert.True(t, report.Healthy)
+	assert.Equal(t, 2, report.Checked)
+
+	blobA := filepath.Join(dir, filepath.FromSlash(blobPath(job.Name, snap.ID, "docs/a.txt")))
+	blobB := filepath.Join(dir, filepath.FromSlash(blobPath(job.Name, snap.ID, "docs/b.txt")))
+	require.NoError(t, os.WriteFile(blobA, []byte("tampered"), 0600))
+	require.NoError(t, os.Remove(blobB))
+
+	report, err = engine.Verify(ctx, job, snap.ID)
+	require.NoError(t, err)
+	assert.False(t, report.Healthy)
+	assert.Equal(t, []string{"docs/a.txt"}, report.Corrupt)
+	assert.Equal(t, []string{"docs/b.txt"}, report.Missing)
+
+	// Restore refuses the corrupt blob rather than writing it
+	store.remove("docs/a.txt")
+	restore, err := engine.Restore(ctx, job, RestoreOptions{Snapshot: snap.ID, Prefix: "docs/a"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, restore.Restored)
+	assert.Equal(t, 1, restore.Failed)
+	_, ok := store.get("docs/a.txt")
+	assert.False(t, ok)
+}
+
+func TestEngine_DeleteAndPrune(t *testing.T) {
+	store := newMemStore()
+	store.put("docs/a.txt", "alpha")
+	job, dir := newTestJob(t)
+	engine := NewEngine(store, store, nil)
+	ctx := context.Background()
+
+	full, err := engine.Backup(ctx, job, BackupTypeFull)
+	require.NoError(t, err)
+	incr, err := engine.Backup(ctx, job, BackupTypeIncremental)
+	require.NoError(t, err)
+
+	err = engine.Delete(ctx, job, full.ID)
+	assert.ErrorIs(t, err, ErrSnapshotInUse)
+	require.NoError(t, engine.Delete(ctx, job, incr.ID))
+	require.NoError(t, engine.Delete(ctx, job, full.ID))
+	assert.ErrorIs(t, engine.Delete(ctx, job, full.ID), ErrSnapshotNotFound)
+
+	job.Keep = 1
+	first, err := engine.Backup(ctx, job, BackupTypeFull)
+	require.NoError(t, err)
+	_, err = engine.Backup(ctx, job, BackupTypeIncremental)
+	require.NoError(t, err)
+	second, err := engine.Backup(ctx, job, BackupTypeFull)
+	require.NoError(t, err)
+
+	snaps, err := engine.Snapshots(ctx, job)
+	require.NoError(t, err)
+	require.Len(t, snaps, 1)
+	assert.Equal(t, second.ID, snaps[0].ID)
+
+	paths, err := NewDirTarget(dir).List(ctx, job.Name+"/blobs/"+first.ID+"/")
+	require.NoError(t, err)
+	assert.Empty(t, paths)
+}
+
+func TestScheduler_RunRecordsStatus(t *testing.T) {
+	store := newMemStore()
+	store.put("docs/a.txt", "alpha")
+	job, _ := newTestJob(t)
+	job.Schedule = "*/5 * * * *"
+	require.NoError(t, job.init())
+
+	sched := NewScheduler(NewEngine(store, store, nil), &Config{Jobs: []*Job{job}}, nil)
+	now := time.Date(2026, 3, 14, 10, 7, 0, 0, time.UTC)
+	sched.now = func() time.Time { return now }
+
+	snap, err := sched.Run(context.Background(), "docs", BackupTypeFull)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 3, 14, 10, 10, 0, 0, time.UTC), sched.plan(now))
+
+	statuses := sched.Jobs()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, snap.ID, statuses[0].LastSnapshot)
+	assert.Equal(t, now, statuses[0].LastRun)
+	assert.False(t, statuses[0].Running)
+
+	_, err = sched.Run(context.Background(), "missing", BackupTypeFull)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+}
+
+func TestScheduler_Register(t *testing.T) {
+	store := newMemStore()
+	store.put("docs/a.txt", "alpha")
+	job, _ := newTestJob(t)
+	job.Schedule = "*/5 * * * *"
+	job.FullSchedule = "0 * * * *"
+	require.NoError(t, job.init())
+
+	sched := NewScheduler(NewEngine(store, store, nil), &Config{Jobs: []*Job{job}}, nil)
+	now := time.Date(2026, 3, 14, 10, 0, 30, 0, time.UTC)
+	sched.now = func() time.Time { return now }
+	jobs := scheduler.New(scheduler.Options{})
+	require.NoError(t, sched.Register(jobs))
+	defer jobs.Stop()
+
+	lastRun := func(name string) scheduler.Run {
+		t.Helper()
+		var run *scheduler.Run
+		require.Eventually(t, func() bool {
+			status, _, err := jobs.Job(name)
+			require.NoError(t, err)
+			run = status.LastRun
+			return run != nil && run.Status != scheduler.Running
+		}, 5*time.Second, time.Millisecond)
+		return *run
+	}
+
+	// The full backup due at the same minute replaces the incremental one
+	_, err := jobs.Trigger("backup.docs")
+	require.NoError(t, err)
+	assert.Equal(t, scheduler.Skipped, lastRun("backup.docs").Status)
+	_, err = jobs.Trigger("backup.docs.full")
+	require.NoError(t, err)
+	assert.Equal(t, scheduler.Succeeded, lastRun("backup.docs.full").Status)
+
+	now = now.Add(5 * time.Minute)
+	_, err = jobs.Trigger("backup.docs")
+	require.NoError(t, err)
+	assert.Equal(t, scheduler.Succeeded, lastRun("backup.docs").Status)
+	snaps, err := sched.Engine().Snapshots(context.Background(), job)
+	require.NoError(t, err)
+	require.Len(t, snaps, 2)
+
+	sched.Unregister(jobs)
+	assert.Empty(t, jobs.Jobs())
+}
+
+// fakeS3 implements the handful of S3 calls the target uses
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AK/") {
+		http.Error(w, "unsigned", http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		type content struct{ Key string }
+		var result struct {
+			XMLName  xml.Name  `xml:"ListBucketResult"`
+			Contents []content `xml:"Contents"`
+		}
+		var keys []string
+		for k := range f.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			result.Contents = append(result.Contents, content{Key: k})
+		}
+		_ = xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objects[key] = data
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3Target_BackupRoundTrip(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	cfg := &Config{Jobs: []*Job{{
+		Name: "docs",
+		Target: TargetConfig{
+			Type: "s3", Bucket: "bucket", Prefix: "backups", Endpoint: srv.URL,
+			AccessKey: "AK", SecretKey: "SK",
+		},
+	}}}
+	require.NoError(t, cfg.Validate())
+	job := cfg.Jobs[0]
+
+	store := newMemStore()
+	store.put("docs/a.txt", "alpha")
+	engine := NewEngine(store, store, nil)
+	ctx := context.Background()
+
+	snap, err := engine.Backup(ctx, job, BackupTypeFull)
+	require.NoError(t, err)
+	assert.Contains(t, fake.objects, "backups/"+manifestPath("docs", snap.ID))
+
+	report, err := engine.Verify(ctx, job, snap.ID)
+	require.NoError(t, err)
+	assert.True(t, report.Healthy)
+
+	store.remove("docs/a.txt")
+	restore, err := engine.Restore(ctx, job, RestoreOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, restore.Restored)
+	got, _ := store.get("docs/a.txt")
+	assert.Equal(t, "alpha", got)
+}
+
+// memPlugin is the storage backend of a plugin
+type memPlugin struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (m *memPlugin) Put(ctx context.Context, key string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = data
+	return nil
+}
+
+func (m *memPlugin) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, plugin.ErrNotFound
+	}
+	return data, nil
+}
+
+func (m *memPlugin) List(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (m *memPlugin) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.objects[key]; !ok {
+		return plugin.ErrNotFound
+	}
+	delete(m.objects, key)
+	return nil
+}
+
+func TestPluginTarget_BackupRoundTrip(t *testing.T) {
+	// Plugins must be launched before jobs writing to them are loaded
+	unlaunched := &Config{Jobs: []*Job{{Name: "docs", Target: TargetConfig{Type: "plugin", Plugin: "vault"}}}}
+	assert.ErrorContains(t, unlaunched.Validate(), "external plugin vault not launched")
+
+	backend := &memPlugin{objects: make(map[string][]byte)}
+	job := &Job{Name: "docs", Target: TargetConfig{Type: "plugin", Plugin: "vault", Prefix: "backups"}}
+	job.target = NewStorageTarget("vault", "backups", backend)
+	require.NoError(t, (&Config{Jobs: []*Job{job}}).Validate())
+	assert.Equal(t, "plugin:vault/backups/", job.TargetName())
+
+	store := newMemStore()
+	store.put("docs/a.txt", "alpha")
+	engine := NewEngine(store, store, nil)
+	ctx := context.Background()
+
+	snap, err := engine.Backup(ctx, job, BackupTypeFull)
+	require.NoError(t, err)
+	assert.Contains(t, backend.objects, "backups/"+manifestPath("docs", snap.ID))
+
+	report, err := engine.Verify(ctx, job, snap.ID)
+	require.NoError(t, err)
+	assert.True(t, report.Healthy)
+
+	store.remove("docs/a.txt")
+	restore, err := engine.Restore(ctx, job, RestoreOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, restore.Restored)
+	got, _ := store.get("docs/a.txt")
+	assert.Equal(t, "alpha", got)
+
+	_, err = job.target.Get(ctx, "docs/missing")
+	assert.ErrorIs(t, err, ErrObjectNotFound)
+	require.NoError(t, job.target.Delete(ctx, "docs/missing"))
+}
@@ -0,0 +1,388 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/Skpow1234/Peervault/internal/scheduler"
+)
+
+// ErrJobNotFound is returned for an unknown job name
+var ErrJobNotFound = errors.New("backup: job not found")
+
+// Job backs up one namespace to one target
+type Job struct {
+	Name string `yaml:"name"`
+	// Namespace is the key prefix to back up; empty means every key
+	Namespace string       `yaml:"namespace"`
+	Target    TargetConfig `yaml:"target"`
+	// Schedule is the cron expression for incremental backups
+	Schedule string `yaml:"schedule,omitempty"`
+	// FullSchedule is the cron expression for full backups
+	FullSchedule string `yaml:"full_schedule,omitempty"`
+	// Keep is the number of full snapshots (with their incrementals) to
+	// keep; 0 keeps everything
+	Keep int `yaml:"keep,omitempty"`
+
+	target       Target
+	schedule     *Schedule
+	fullSchedule *Schedule
+}
+
+// Config is the backup section of the API configuration
+type Config struct {
+	Jobs []*Job `yaml:"jobs"`
+}
+
+// LoadConfig reads backup jobs from a YAML file. ${VAR} references are
+// expanded from the environment so credentials can stay out of the file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(data))), &cfg); err != nil {
+		return nil, fmt.Errorf("backup: invalid config %s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate checks every job and prepares its target and schedules
+func (c *Config) Validate() error {
+	seen := make(map[string]bool)
+	for _, job := range c.Jobs {
+		if job.Name == "" {
+			return errors.New("backup: job name is required")
+		}
+		if seen[job.Name] {
+			return fmt.Errorf("backup: duplicate job %q", job.Name)
+		}
+		seen[job.Name] = true
+		if err := job.init(); err != nil {
+			return fmt.Errorf("backup: job %q: %w", job.Name, err)
+		}
+	}
+	return nil
+}
+
+func (j *Job) init() error {
+	if _, err := cleanPath(j.Name); err != nil {
+		return errors.New("name must be a plain path segment")
+	}
+	if j.Keep < 0 {
+		return errors.New("keep must not be negative")
+	}
+	var err error
+	if j.Schedule != "" {
+		if j.schedule, err = ParseSchedule(j.Schedule); err != nil {
+			return err
+		}
+	}
+	if j.FullSchedule != "" {
+		if j.fullSchedule, err = ParseSchedule(j.FullSchedule); err != nil {
+			return err
+		}
+	}
+	if j.target == nil {
+		if j.target, err = NewTarget(j.Target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TargetName describes where the job writes
+func (j *Job) TargetName() string {
+	if j.target == nil {
+		return j.Target.Type
+	}
+	return j.target.String()
+}
+
+// JobStatus reports a job's configuration and recent activity
+type JobStatus struct {
+	Name         string    `json:"name"`
+	Namespace    string    `json:"namespace"`
+	Target       string    `json:"target"`
+	Schedule     string    `json:"schedule,omitempty"`
+	FullSchedule string    `json:"full_schedule,omitempty"`
+	Keep         int       `json:"keep"`
+	Running      bool      `json:"running"`
+	LastRun      time.Time `json:"last_run,omitempty"`
+	LastSnapshot string    `json:"last_snapshot,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+	NextRun      time.Time `json:"next_run,omitempty"`
+}
+
+// Scheduler runs backup jobs on their cron schedules and on demand
+type Scheduler struct {
+	engine *Engine
+	jobs   []*Job
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu     sync.Mutex
+	status map[string]*JobStatus
+	next   map[string]time.Time
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewScheduler creates a scheduler for the jobs of a validated config
+func NewScheduler(engine *Engine, cfg *Config, logger *slog.Logger) *Scheduler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	s := &Scheduler{
+		engine: engine,
+		logger: logger,
+		now:    time.Now,
+		status: make(map[string]*JobStatus),
+		next:   make(map[string]time.Time),
+	}
+	if cfg != nil {
+		s.jobs = cfg.Jobs
+	}
+	for _, job := range s.jobs {
+		s.status[job.Name] = &JobStatus{
+			Name:         job.Name,
+			Namespace:    job.Namespace,
+			Target:       job.TargetName(),
+			Schedule:     job.Schedule,
+			FullSchedule: job.FullSchedule,
+			Keep:         job.Keep,
+		}
+	}
+	return s
+}
+
+// Engine returns the engine that runs the jobs
+func (s *Scheduler) Engine() *Engine {
+	return s.engine
+}
+
+// Job looks up a job by name
+func (s *Scheduler) Job(name string) (*Job, error) {
+	for _, job := range s.jobs {
+		if job.Name == name {
+			return job, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrJobNotFound, name)
+}
+
+// Jobs returns the status of every job in configuration order
+func (s *Scheduler) Jobs() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]JobStatus, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		st := *s.status[job.Name]
+		st.Running = s.engine.Running(job.Name)
+		st.NextRun = s.next[job.Name]
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
+
+// Run takes a backup now and records the outcome in the job status
+func (s *Scheduler) Run(ctx context.Context, name string, typ BackupType) (*Snapshot, error) {
+	return s.RunFrom(ctx, name, typ, s.engine.source)
+}
+
+// RunFrom takes a backup now from source instead of the live files
+func (s *Scheduler) RunFrom(ctx context.Context, name string, typ BackupType, source Source) (*Snapshot, error) {
+	job, err := s.Job(name)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := s.engine.BackupFrom(ctx, job, typ, source)
+	if errors.Is(err, ErrJobRunning) {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	st := s.status[name]
+	st.LastRun = s.now()
+	if err != nil {
+		st.LastError = err.Error()
+	} else {
+		st.LastError = ""
+		st.LastSnapshot = snap.ID
+	}
+	s.mu.Unlock()
+	return snap, err
+}
+
+// Trigger starts a backup in the background; it fails fast if the job is
+// unknown or already running
+func (s *Scheduler) Trigger(name string, typ BackupType) error {
+	if _, err := s.Job(name); err != nil {
+		return err
+	}
+	if s.engine.Running(name) {
+		return ErrJobRunning
+	}
+	go func() {
+		if _, err := s.Run(context.Background(), name, typ); err != nil {
+			s.logger.Error("Backup failed", "job", name, "type", typ, "error", err)
+		}
+	}()
+	return nil
+}
+
+// Register puts the jobs on a node scheduler, which runs them instead of
+// Start: "backup.<name>" takes incremental backups on the job's schedule and
+// "backup.<name>.full" full ones on its full schedule. Jobs without a
+// schedule only run when triggered.
+func (s *Scheduler) Register(sched *scheduler.Scheduler) error {
+	for _, job := range s.jobs {
+		incremental := scheduler.Job{
+			Name:        "backup." + job.Name,
+			Description: fmt.Sprintf("Back up %s incrementally", job.Namespace),
+			Schedule:    job.Schedule,
+			Run: func(ctx context.Context) error {
+				// A due full schedule wins over an incremental one firing
+				// at the same time
+				if now := s.now().Truncate(time.Minute); job.fullSchedule != nil && !job.fullSchedule.Next(now.Add(-time.Minute)).After(now) {
+					return fmt.Errorf("%w: a full backup is due", scheduler.ErrSkipped)
+				}
+				_, err := s.Run(ctx, job.Name, BackupTypeIncremental)
+				return err
+			},
+		}
+		full := scheduler.Job{
+			Name:        "backup." + job.Name + ".full",
+			Description: fmt.Sprintf("Back up %s in full", job.Namespace),
+			Schedule:    job.FullSchedule,
+			Run: func(ctx context.Context) error {
+				_, err := s.Run(ctx, job.Name, BackupTypeFull)
+				return err
+			},
+		}
+		for _, j := range []scheduler.Job{incremental, full} {
+			if err := sched.Register(j); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Unregister takes the jobs off a node scheduler
+func (s *Scheduler) Unregister(sched *scheduler.Scheduler) {
+	for _, job := range s.jobs {
+		sched.Unregister("backup." + job.Name)
+		sched.Unregister("backup." + job.Name + ".full")
+	}
+}
+
+// Start runs scheduled jobs until Stop is called
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	if s.stop != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	stop, done := s.stop, s.done
+	s.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		for {
+			wait := time.Minute
+			if next := s.plan(s.now()); !next.IsZero() {
+				wait = max(time.Until(next), 0)
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+				s.tick(s.now())
+			}
+		}
+	}()
+}
+
+// Stop stops scheduling; backups already running finish on their own
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// plan records each job's next run after now and returns the earliest
+func (s *Scheduler) plan(now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var earliest time.Time
+	for _, job := range s.jobs {
+		next, ok := s.next[job.Name]
+		if !ok || next.IsZero() {
+			next = nextRun(job, now)
+			s.next[job.Name] = next
+		}
+		if !next.IsZero() && (earliest.IsZero() || next.Before(earliest)) {
+			earliest = next
+		}
+	}
+	return earliest
+}
+
+// tick starts every job that is due; a due full schedule wins over an
+// incremental one firing at the same time
+func (s *Scheduler) tick(now time.Time) {
+	for _, job := range s.jobs {
+		s.mu.Lock()
+		next := s.next[job.Name]
+		due := !next.IsZero() && !next.After(now)
+		if due {
+			s.next[job.Name] = time.Time{}
+		}
+		s.mu.Unlock()
+		if !due {
+			continue
+		}
+
+		typ := BackupTypeIncremental
+		if job.fullSchedule != nil && !job.fullSchedule.Next(next.Add(-time.Minute)).After(next) {
+			typ = BackupTypeFull
+		}
+		if err := s.Trigger(job.Name, typ); err != nil {
+			s.logger.Warn("Skipping scheduled backup", "job", job.Name, "error", err)
+		}
+	}
+}
+
+func nextRun(job *Job, now time.Time) time.Time {
+	var next time.Time
+	for _, sched := range []*Schedule{job.schedule, job.fullSchedule} {
+		if sched == nil {
+			continue
+		}
+		if t := sched.Next(now); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next
+}
@@ -0,0 +1,115 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/plugins"
+	"github.com/Skpow1234/Peervault/pkg/plugin"
+)
+
+// PluginTarget stores backups in the storage backend of an external
+// plugin. Objects are sent whole, so plugins suit backups of moderately
+// sized files.
+type PluginTarget struct {
+	name    string
+	prefix  string
+	storage plugin.Storage
+}
+
+// NewPluginTarget creates a target writing to the launched plugin named
+// by cfg.Plugin
+func NewPluginTarget(cfg TargetConfig) (*PluginTarget, error) {
+	client, err := plugins.GetExternal(cfg.Plugin)
+	if err != nil {
+		return nil, fmt.Errorf("backup: %w", err)
+	}
+	storage := client.Storage()
+	if storage == nil {
+		return nil, fmt.Errorf("backup: plugin %s provides no storage", cfg.Plugin)
+	}
+	return NewStorageTarget(cfg.Plugin, cfg.Prefix, storage), nil
+}
+
+// NewStorageTarget creates a target keeping objects under prefix in
+// storage
+func NewStorageTarget(name, prefix string, storage plugin.Storage) *PluginTarget {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &PluginTarget{name: name, prefix: prefix, storage: storage}
+}
+
+func (t *PluginTarget) key(p string) (string, error) {
+	clean, err := cleanPath(p)
+	if err != nil {
+		return "", err
+	}
+	return t.prefix + clean, nil
+}
+
+func (t *PluginTarget) Put(ctx context.Context, p string, r io.Reader, size int64) error {
+	key, err := t.key(p)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if size > 0 {
+		buf.Grow(int(size))
+	}
+	if _, err := io.Copy(&buf, r); err != nil {
+		return err
+	}
+	return t.storage.Put(ctx, key, buf.Bytes())
+}
+
+func (t *PluginTarget) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	key, err := t.key(p)
+	if err != nil {
+		return nil, err
+	}
+	data, err := t.storage.Get(ctx, key)
+	if errors.Is(err, plugin.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (t *PluginTarget) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := t.storage.List(ctx, t.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if p, ok := strings.CutPrefix(key, t.prefix); ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (t *PluginTarget) Delete(ctx context.Context, p string) error {
+	key, err := t.key(p)
+	if err != nil {
+		return err
+	}
+	err = t.storage.Delete(ctx, key)
+	if errors.Is(err, plugin.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (t *PluginTarget) String() string {
+	return "plugin:" + t.name + "/" + t.prefix
+}
@@ -0,0 +1,197 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// remotePathKey is the metadata attribute holding a blob's target path
+	// on the receiving cluster
+	remotePathKey = "backup_path"
+	remoteTag     = "backup"
+)
+
+// PeerVaultTarget stores backups as files on another PeerVault cluster
+// through its REST API. Each object becomes a file tagged "backup" whose
+// backup_path attribute records its path within the target.
+type PeerVaultTarget struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+type remoteFile struct {
+	Key      string            `json:"key"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// NewPeerVaultTarget creates a target writing to the cluster at cfg.URL
+func NewPeerVaultTarget(cfg TargetConfig) (*PeerVaultTarget, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("backup: invalid peervault url %q", cfg.URL)
+	}
+	return &PeerVaultTarget{
+		baseURL: strings.TrimSuffix(cfg.URL, "/") + "/api/v1",
+		token:   cfg.Token,
+		client:  &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+func (t *PeerVaultTarget) Put(ctx context.Context, p string, r io.Reader, size int64) error {
+	if _, err := cleanPath(p); err != nil {
+		return err
+	}
+	existing, err := t.find(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", p[strings.LastIndex(p, "/")+1:])
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return err
+	}
+	attrs, _ := json.Marshal(map[string]string{remotePathKey: p})
+	_ = mw.WriteField("metadata", string(attrs))
+	_ = mw.WriteField("tags", remoteTag)
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	resp, err := t.do(ctx, http.MethodPost, "/files", &body, mw.FormDataContentType())
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	// Uploads always create a new file, so drop the ones this replaced
+	for _, f := range existing {
+		if err := t.deleteKey(ctx, f.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *PeerVaultTarget) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	files, err := t.find(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, p)
+	}
+	resp, err := t.do(ctx, http.MethodGet, "/files/"+url.PathEscape(files[0].Key)+"/content", nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (t *PeerVaultTarget) List(ctx context.Context, prefix string) ([]string, error) {
+	files, err := t.list(ctx, url.Values{"tag": {remoteTag}})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var paths []string
+	for _, f := range files {
+		p := f.Metadata[remotePathKey]
+		if p != "" && strings.HasPrefix(p, prefix) && !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (t *PeerVaultTarget) Delete(ctx context.Context, p string) error {
+	files, err := t.find(ctx, p)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := t.deleteKey(ctx, f.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *PeerVaultTarget) String() string {
+	return "peervault:" + strings.TrimSuffix(t.baseURL, "/api/v1")
+}
+
+func (t *PeerVaultTarget) find(ctx context.Context, p string) ([]remoteFile, error) {
+	return t.list(ctx, url.Values{"tag": {remoteTag}, "meta." + remotePathKey: {p}})
+}
+
+func (t *PeerVaultTarget) list(ctx context.Context, query url.Values) ([]remoteFile, error) {
+	resp, err := t.do(ctx, http.MethodGet, "/files?"+query.Encode(), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Files []remoteFile `json:"files"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("backup: decoding file list from %s: %w", t, err)
+	}
+	return result.Files, nil
+}
+
+func (t *PeerVaultTarget) deleteKey(ctx context.Context, key string) error {
+	resp, err := t.do(ctx, http.MethodDelete, "/files?key="+url.QueryEscape(key), nil, "")
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return err
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// do sends an authenticated request and turns non-2xx responses into errors
+func (t *PeerVaultTarget) do(ctx context.Context, method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, t.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, path)
+	}
+	return nil, fmt.Errorf("backup: %s %s: %s: %s", method, t, resp.Status, strings.TrimSpace(string(msg)))
+}
@@ -0,0 +1,180 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrObjectNotFound is returned by a Target when a path does not exist
+var ErrObjectNotFound = errors.New("backup: object not found")
+
+// Target stores snapshot manifests and blobs under slash-separated paths
+type Target interface {
+	Put(ctx context.Context, p string, r io.Reader, size int64) error
+	Get(ctx context.Context, p string) (io.ReadCloser, error)
+	// List returns every path under prefix
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, p string) error
+	String() string
+}
+
+// TargetConfig selects and configures a backup target
+type TargetConfig struct {
+	// Type is "dir", "s3", "peervault" or "plugin"
+	Type string `yaml:"type" json:"type"`
+
+	// Path is the root directory of a dir target
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+
+	// S3 and S3-compatible object stores
+	Bucket    string `yaml:"bucket,omitempty" json:"bucket,omitempty"`
+	Prefix    string `yaml:"prefix,omitempty" json:"prefix,omitempty"`
+	Region    string `yaml:"region,omitempty" json:"region,omitempty"`
+	Endpoint  string `yaml:"endpoint,omitempty" json:"endpoint,omitempty"`
+	AccessKey string `yaml:"access_key,omitempty" json:"-"`
+	SecretKey string `yaml:"secret_key,omitempty" json:"-"`
+
+	// URL and Token address the REST API of another PeerVault cluster
+	URL   string `yaml:"url,omitempty" json:"url,omitempty"`
+	Token string `yaml:"token,omitempty" json:"-"`
+
+	// Plugin names the launched external plugin whose storage a plugin
+	// target writes to, under Prefix
+	Plugin string `yaml:"plugin,omitempty" json:"plugin,omitempty"`
+}
+
+// NewTarget builds the target described by cfg
+func NewTarget(cfg TargetConfig) (Target, error) {
+	switch cfg.Type {
+	case "dir", "":
+		if cfg.Path == "" {
+			return nil, errors.New("backup: dir target requires a path")
+		}
+		return NewDirTarget(cfg.Path), nil
+	case "s3":
+		return NewS3Target(cfg)
+	case "peervault":
+		return NewPeerVaultTarget(cfg)
+	case "plugin":
+		return NewPluginTarget(cfg)
+	default:
+		return nil, fmt.Errorf("backup: unknown target type %q", cfg.Type)
+	}
+}
+
+// cleanPath rejects paths that could escape the target root
+func cleanPath(p string) (string, error) {
+	clean := path.Clean("/" + p)[1:]
+	if clean == "" || clean != p || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("backup: invalid target path %q", p)
+	}
+	return clean, nil
+}
+
+// DirTarget keeps backups in a local (or mounted) directory
+type DirTarget struct {
+	root string
+}
+
+// NewDirTarget creates a target rooted at dir
+func NewDirTarget(dir string) *DirTarget {
+	return &DirTarget{root: dir}
+}
+
+func (t *DirTarget) resolve(p string) (string, error) {
+	clean, err := cleanPath(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(t.root, filepath.FromSlash(clean)), nil
+}
+
+func (t *DirTarget) Put(ctx context.Context, p string, r io.Reader, size int64) error {
+	dest, err := t.resolve(p)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file so readers never see a partial object
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+func (t *DirTarget) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	src, err := t.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(src)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, p)
+	}
+	return f, err
+}
+
+func (t *DirTarget) List(ctx context.Context, prefix string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(t.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".put-") {
+			return nil
+		}
+		rel, err := filepath.Rel(t.root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.HasPrefix(rel, prefix) {
+			paths = append(paths, rel)
+		}
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, err
+}
+
+func (t *DirTarget) Delete(ctx context.Context, p string) error {
+	dest, err := t.resolve(p)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(dest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (t *DirTarget) String() string {
+	return "dir:" + t.root
+}
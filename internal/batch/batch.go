@@ -0,0 +1,215 @@
+// Package batch runs lists of mixed file operations, so bulk workflows
+// take one round trip instead of one per file. Operations run concurrently
+// with bounded parallelism and each gets its own result; one failing does
+// not stop the others.
+package batch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Kinds of operations
+const (
+	// OpStore stores content given inline or fetched from a URL
+	OpStore  = "store"
+	OpDelete = "delete"
+	// OpCopy copies a file on the server, see the copy endpoint
+	OpCopy = "copy"
+	// OpTag adds and removes tags and metadata entries
+	OpTag = "tag"
+)
+
+const (
+	// DefaultParallelism is how many operations run at once when a batch
+	// does not say
+	DefaultParallelism = 8
+	// MaxParallelism caps the operations of a batch running at once
+	MaxParallelism = 32
+	// MaxOperations is the most operations one batch holds
+	MaxOperations = 1000
+	// MaxFetchSize is the largest content a store fetches from a URL
+	MaxFetchSize = 64 << 20
+	// FetchTimeout bounds fetching the content of a store
+	FetchTimeout = time.Minute
+)
+
+var (
+	// ErrInvalid is returned for batches and operations missing what their
+	// kind needs
+	ErrInvalid = errors.New("batch: invalid operation")
+	// ErrFetch is returned when the content of a store cannot be fetched
+	ErrFetch = errors.New("batch: fetching content failed")
+)
+
+// Operation is one item of a batch. Which fields apply depends on Op.
+type Operation struct {
+	Op string `json:"op"`
+	// Key is the file a store writes and a delete or tag changes
+	Key string `json:"key,omitempty"`
+
+	// Content is the content of a small store; larger ones give URL, which
+	// the server fetches, instead
+	Content     []byte            `json:"content,omitempty"`
+	URL         string            `json:"url,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+
+	// From, To, Tenant and Overwrite describe a copy, which also sets
+	// Metadata on the copy
+	From      string `json:"from,omitempty"`
+	To        string `json:"to,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+
+	// AddTags, RemoveTags, Set and Remove describe a tag operation
+	AddTags    []string          `json:"add_tags,omitempty"`
+	RemoveTags []string          `json:"remove_tags,omitempty"`
+	Set        map[string]string `json:"set,omitempty"`
+	Remove     []string          `json:"remove,omitempty"`
+}
+
+// Target returns the key an operation writes or removes
+func (o Operation) Target() string {
+	if o.Op == OpCopy {
+		return o.To
+	}
+	return o.Key
+}
+
+// Validate checks that an operation has what its kind needs
+func (o Operation) Validate() error {
+	switch o.Op {
+	case OpStore:
+		if o.Key == "" {
+			return fmt.Errorf("%w: store needs a key", ErrInvalid)
+		}
+		if (o.URL == "") == (o.Content == nil) {
+			return fmt.Errorf("%w: store needs either content or a url", ErrInvalid)
+		}
+		if o.URL != "" {
+			u, err := url.Parse(o.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("%w: store needs an http or https url", ErrInvalid)
+			}
+		}
+	case OpDelete:
+		if o.Key == "" {
+			return fmt.Errorf("%w: delete needs a key", ErrInvalid)
+		}
+	case OpCopy:
+		if o.From == "" || o.To == "" {
+			return fmt.Errorf("%w: copy needs from and to", ErrInvalid)
+		}
+	case OpTag:
+		if o.Key == "" {
+			return fmt.Errorf("%w: tag needs a key", ErrInvalid)
+		}
+		if len(o.AddTags)+len(o.RemoveTags)+len(o.Set)+len(o.Remove) == 0 {
+			return fmt.Errorf("%w: tag needs tags or metadata to change", ErrInvalid)
+		}
+	default:
+		return fmt.Errorf("%w: unknown op %q", ErrInvalid, o.Op)
+	}
+	return nil
+}
+
+// Validate checks a whole batch before any of it runs, naming the first
+// operation at fault
+func Validate(ops []Operation) error {
+	if len(ops) == 0 {
+		return fmt.Errorf("%w: the batch is empty", ErrInvalid)
+	}
+	if len(ops) > MaxOperations {
+		return fmt.Errorf("%w: a batch holds at most %d operations", ErrInvalid, MaxOperations)
+	}
+	for i, op := range ops {
+		if err := op.Validate(); err != nil {
+			return fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Outcome is what an operation returned
+type Outcome struct {
+	Value any
+	Err   error
+}
+
+// Run calls do for every operation, at most parallelism at once, and
+// returns their outcomes in the order of ops. Operations run in no
+// particular order; a batch whose operations depend on each other runs
+// with a parallelism of 1, which keeps the order given. Operations not yet
+// started when ctx is done fail with its error.
+func Run(ctx context.Context, ops []Operation, parallelism int, do func(context.Context, Operation) (any, error)) []Outcome {
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
+	parallelism = min(parallelism, MaxParallelism)
+
+	outcomes := make([]Outcome, len(ops))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, op := range ops {
+		if err := ctx.Err(); err != nil {
+			outcomes[i].Err = err
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			outcomes[i].Err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			value, err := do(ctx, op)
+			outcomes[i] = Outcome{Value: value, Err: err}
+		}()
+	}
+	wg.Wait()
+	return outcomes
+}
+
+// Fetch returns the content of a store: Content, or what client fetched
+// from URL, up to MaxFetchSize bytes
+func (o Operation) Fetch(ctx context.Context, client *http.Client) ([]byte, error) {
+	if o.URL == "" {
+		return o.Content, nil
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ctx, cancel := context.WithTimeout(ctx, FetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFetch, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFetch, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%w: %s answered %s", ErrFetch, o.URL, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxFetchSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFetch, err)
+	}
+	if len(data) > MaxFetchSize {
+		return nil, fmt.Errorf("%w: %s is larger than %d bytes", ErrFetch, o.URL, MaxFetchSize)
+	}
+	return data, nil
+}
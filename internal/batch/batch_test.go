@@ -0,0 +1,104 @@
+package batch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunBoundsParallelism(t *testing.T) {
+	ops := make([]Operation, 40)
+	for i := range ops {
+		ops[i] = Operation{Op: OpDelete, Key: fmt.Sprint(i)}
+	}
+	var running, peak atomic.Int32
+	outcomes := Run(context.Background(), ops, 4, func(ctx context.Context, op Operation) (any, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		if op.Key == "7" {
+			return nil, fmt.Errorf("failed")
+		}
+		return op.Key, nil
+	})
+
+	require.Len(t, outcomes, 40)
+	assert.LessOrEqual(t, peak.Load(), int32(4))
+	for i, outcome := range outcomes {
+		if i == 7 {
+			assert.Error(t, outcome.Err)
+			continue
+		}
+		assert.NoError(t, outcome.Err)
+		assert.Equal(t, fmt.Sprint(i), outcome.Value, "outcomes keep the order of the operations")
+	}
+}
+
+func TestRunStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ops := make([]Operation, 5)
+	outcomes := Run(ctx, ops, 1, func(ctx context.Context, op Operation) (any, error) {
+		cancel()
+		return nil, nil
+	})
+	assert.NoError(t, outcomes[0].Err)
+	assert.ErrorIs(t, outcomes[4].Err, context.Canceled)
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate([]Operation{
+		{Op: OpStore, Key: "a", Content: []byte{}},
+		{Op: OpStore, Key: "b", URL: "https://example.com/b"},
+		{Op: OpCopy, From: "a", To: "c"},
+		{Op: OpTag, Key: "a", AddTags: []string{"x"}},
+		{Op: OpDelete, Key: "a"},
+	}))
+	for _, op := range []Operation{
+		{Op: "rename", Key: "a"},
+		{Op: OpStore, Key: "a"},
+		{Op: OpStore, Key: "a", Content: []byte("x"), URL: "https://example.com/a"},
+		{Op: OpStore, Key: "a", URL: "ftp://example.com/a"},
+		{Op: OpCopy, From: "a"},
+		{Op: OpTag, Key: "a"},
+		{Op: OpDelete},
+	} {
+		assert.ErrorIs(t, Validate([]Operation{op}), ErrInvalid, "%+v", op)
+	}
+	assert.ErrorIs(t, Validate(nil), ErrInvalid)
+	assert.ErrorIs(t, Validate(make([]Operation, MaxOperations+1)), ErrInvalid)
+}
+
+func TestFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/big" {
+			_, _ = w.Write([]byte(strings.Repeat("x", MaxFetchSize+1)))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	data, err := Operation{Content: []byte("inline")}.Fetch(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "inline", string(data))
+
+	_, err = Operation{URL: srv.URL + "/missing"}.Fetch(ctx, srv.Client())
+	assert.ErrorIs(t, err, ErrFetch)
+	_, err = Operation{URL: srv.URL + "/big"}.Fetch(ctx, srv.Client())
+	assert.ErrorIs(t, err, ErrFetch)
+}
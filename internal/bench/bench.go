@@ -0,0 +1,241 @@
+// Package bench benchmarks a PeerVault cluster. It runs a workload of
+// reads and writes with a configurable object size distribution and
+// concurrency against the REST API and reports throughput, latency
+// percentiles and error rates as JSON, which can be compared with an
+// earlier report to catch performance regressions.
+package bench
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Skpow1234/Peervault/pkg/client"
+)
+
+// Target is the cluster a benchmark runs against
+type Target interface {
+	// Put stores size bytes from r under name and returns the key to read
+	// them back with
+	Put(ctx context.Context, name string, r io.ReadSeeker, size int64) (string, error)
+	// Get reads the object stored under key and returns its size
+	Get(ctx context.Context, key string) (int64, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// ClientTarget runs benchmarks through the Go client
+type ClientTarget struct {
+	Client *client.Client
+}
+
+// Put implements Target
+func (t ClientTarget) Put(ctx context.Context, name string, r io.ReadSeeker, size int64) (string, error) {
+	file, err := t.Client.Store(ctx, name, r, &client.StoreOptions{ContentType: "application/octet-stream"})
+	if err != nil {
+		return "", err
+	}
+	return file.Key, nil
+}
+
+// Get implements Target
+func (t ClientTarget) Get(ctx context.Context, key string) (int64, error) {
+	return t.Client.Download(ctx, key, io.Discard)
+}
+
+// Delete implements Target
+func (t ClientTarget) Delete(ctx context.Context, key string) error {
+	return t.Client.Delete(ctx, key)
+}
+
+// Op is the kind of an operation
+type Op string
+
+const (
+	OpRead  Op = "read"
+	OpWrite Op = "write"
+)
+
+// Runner runs workloads against a target
+type Runner struct {
+	target Target
+	logger *slog.Logger
+}
+
+// NewRunner creates a runner; a nil logger discards progress messages
+func NewRunner(target Target, logger *slog.Logger) *Runner {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return &Runner{target: target, logger: logger}
+}
+
+// run is the state of one workload run
+type run struct {
+	w       Workload
+	target  Target
+	payload []byte
+
+	ops     atomic.Int64
+	mu      sync.Mutex
+	keys    []string
+	results map[Op]*recorder
+}
+
+// Run runs the workload until its duration or operation count is reached,
+// or ctx is done, and reports how it went. Failed operations are counted
+// in the report; Run only fails when the workload cannot start.
+func (r *Runner) Run(ctx context.Context, w Workload) (*Report, error) {
+	if err := w.Validate(); err != nil {
+		return nil, err
+	}
+	if w.Seed == 0 {
+		w.Seed = rand.Uint64()
+	}
+
+	// One random payload, sliced to the size of each object; objects share
+	// their leading bytes but cannot be compressed away
+	payload := make([]byte, w.Sizes.Max())
+	if _, err := crand.Read(payload); err != nil {
+		return nil, fmt.Errorf("bench: %w", err)
+	}
+	state := &run{
+		w:       w,
+		target:  r.target,
+		payload: payload,
+		results: map[Op]*recorder{OpRead: newRecorder(), OpWrite: newRecorder()},
+	}
+	defer r.cleanup(state)
+
+	if w.ReadRatio > 0 && w.Prefill > 0 {
+		r.logger.Info("Prefilling objects", "count", w.Prefill)
+		if err := state.prefill(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	runCtx := ctx
+	if w.Duration > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, w.Duration)
+		defer cancel()
+	}
+	r.logger.Info("Starting benchmark", "concurrency", w.Concurrency, "read_ratio", w.ReadRatio, "sizes", w.Sizes.String(), "seed", w.Seed)
+
+	started := time.Now()
+	var wg sync.WaitGroup
+	for n := 0; n < w.Concurrency; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			state.worker(runCtx, rand.New(rand.NewPCG(w.Seed, uint64(n))), n)
+		}(n)
+	}
+	wg.Wait()
+	elapsed := time.Since(started)
+
+	report := newReport(w, started, elapsed, state.results)
+	r.logger.Info("Benchmark finished", "ops", report.Total.Ops, "errors", report.Total.Errors, "elapsed", elapsed.Round(time.Millisecond))
+	return report, nil
+}
+
+// prefill writes the objects that the first reads fetch. Unlike the
+// measured writes, a failure here stops the run: the cluster is not
+// usable.
+func (s *run) prefill(ctx context.Context) error {
+	rng := rand.New(rand.NewPCG(s.w.Seed, ^uint64(0)))
+	for n := 0; n < s.w.Prefill; n++ {
+		size := s.w.Sizes.pick(rng)
+		key, err := s.target.Put(ctx, s.name("prefill", n), bytes.NewReader(s.payload[:size]), size)
+		if err != nil {
+			return fmt.Errorf("bench: prefill failed: %w", err)
+		}
+		s.addKey(key)
+	}
+	return nil
+}
+
+// worker runs operations until the context is done or the workload has
+// run all of its operations
+func (s *run) worker(ctx context.Context, rng *rand.Rand, id int) {
+	for seq := 0; ctx.Err() == nil; seq++ {
+		if s.w.Ops > 0 && s.ops.Add(1) > s.w.Ops {
+			return
+		}
+		key, ok := "", false
+		if rng.Float64() < s.w.ReadRatio {
+			key, ok = s.randomKey(rng)
+		}
+
+		start := time.Now()
+		var (
+			op   Op
+			size int64
+			err  error
+		)
+		if ok {
+			op = OpRead
+			size, err = s.target.Get(ctx, key)
+		} else {
+			// Nothing to read yet: write instead
+			op = OpWrite
+			size = s.w.Sizes.pick(rng)
+			key, err = s.target.Put(ctx, s.name(fmt.Sprintf("w%d", id), seq), bytes.NewReader(s.payload[:size]), size)
+			if err == nil {
+				s.addKey(key)
+			}
+		}
+		latency := time.Since(start)
+
+		// Operations cut off by the end of the run are not errors
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+		s.results[op].record(latency, size, err)
+	}
+}
+
+func (s *run) name(worker string, seq int) string {
+	return fmt.Sprintf("%s%x-%s-%d", s.w.Prefix, s.w.Seed, worker, seq)
+}
+
+func (s *run) addKey(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, key)
+}
+
+func (s *run) randomKey(rng *rand.Rand) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.keys) == 0 {
+		return "", false
+	}
+	return s.keys[rng.IntN(len(s.keys))], true
+}
+
+// cleanup deletes the objects written, unless the workload keeps them.
+// It runs after the workload's context may be done, so it has its own.
+func (r *Runner) cleanup(s *run) {
+	if s.w.Keep || len(s.keys) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	r.logger.Info("Deleting benchmark objects", "count", len(s.keys))
+	failed := 0
+	for _, key := range s.keys {
+		if err := s.target.Delete(ctx, key); err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		r.logger.Warn("Failed to delete benchmark objects", "count", failed)
+	}
+}
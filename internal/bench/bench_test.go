@@ -0,0 +1,218 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memTarget stores objects in memory, failing every failEvery-th write
+type memTarget struct {
+	mu        sync.Mutex
+	objects   map[string]int64
+	writes    int
+	failEvery int
+	deleted   int
+}
+
+func newMemTarget() *memTarget {
+	return &memTarget{objects: make(map[string]int64)}
+}
+
+func (m *memTarget) Put(ctx context.Context, name string, r io.ReadSeeker, size int64) (string, error) {
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writes++
+	if m.failEvery > 0 && m.writes%m.failEvery == 0 {
+		return "", errors.New("disk full")
+	}
+	if n != size {
+		return "", fmt.Errorf("wrote %d bytes, want %d", n, size)
+	}
+	m.objects[name] = n
+	return name, nil
+}
+
+func (m *memTarget) Get(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	size, ok := m.objects[key]
+	if !ok {
+		return 0, errors.New("not found")
+	}
+	return size, nil
+}
+
+func (m *memTarget) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	m.deleted++
+	return nil
+}
+
+func testWorkload(t *testing.T, sizes string) Workload {
+	t.Helper()
+	w := DefaultWorkload()
+	w.Duration = 0
+	w.Ops = 200
+	w.Concurrency = 4
+	w.Seed = 42
+	require.NoError(t, w.Sizes.Set(sizes))
+	return w
+}
+
+func TestParseSizes(t *testing.T) {
+	for spec, want := range map[string]int64{
+		"512": 512, "4KiB": 4096, "4kib": 4096, "10MB": 10_000_000, "1M": 1 << 20, "2 GiB": 2 << 30, "7B": 7,
+	} {
+		got, err := ParseSize(spec)
+		require.NoError(t, err, spec)
+		assert.Equal(t, want, got, spec)
+	}
+
+	sizes, err := ParseSizes("1KiB:3, 4KiB-8KiB:1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(8192), sizes.Max())
+	rng := rand.New(rand.NewPCG(1, 1))
+	small := 0
+	for range 1000 {
+		size := sizes.pick(rng)
+		if size == 1024 {
+			small++
+		} else {
+			assert.True(t, size >= 4096 && size <= 8192, size)
+		}
+	}
+	assert.InDelta(t, 750, small, 60)
+
+	for _, spec := range []string{"", "big", "4KiB:0", "8KiB-4KiB", "2GiB", "-1"} {
+		_, err := ParseSizes(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestValidateWorkload(t *testing.T) {
+	w := testWorkload(t, "1KiB")
+	assert.NoError(t, w.Validate())
+
+	for name, change := range map[string]func(*Workload){
+		"no limit":    func(w *Workload) { w.Ops = 0 },
+		"concurrency": func(w *Workload) { w.Concurrency = 0 },
+		"read ratio":  func(w *Workload) { w.ReadRatio = 1.5 },
+		"sizes":       func(w *Workload) { w.Sizes = Sizes{} },
+	} {
+		invalid := w
+		change(&invalid)
+		assert.Error(t, invalid.Validate(), name)
+	}
+}
+
+func TestRunCountsOperations(t *testing.T) {
+	target := newMemTarget()
+	w := testWorkload(t, "1KiB-4KiB")
+	w.ReadRatio = 0.5
+
+	report, err := NewRunner(target, nil).Run(context.Background(), w)
+	require.NoError(t, err)
+	assert.Equal(t, int64(200), report.Total.Ops)
+	assert.Equal(t, report.Total.Ops, report.Read.Ops+report.Write.Ops)
+	assert.InDelta(t, 100, report.Read.Ops, 30)
+	assert.Zero(t, report.Total.Errors)
+	assert.Positive(t, report.Total.OpsPerSec)
+	assert.Positive(t, report.Total.Bytes)
+	assert.LessOrEqual(t, report.Total.Latency.P50, report.Total.Latency.P99)
+	assert.LessOrEqual(t, report.Total.Latency.P99, report.Total.Latency.Max)
+
+	// Prefilled and written objects are deleted afterwards
+	assert.Empty(t, target.objects)
+	assert.Equal(t, int(report.Write.Ops)+DefaultPrefill, target.deleted)
+}
+
+func TestRunRecordsErrors(t *testing.T) {
+	target := newMemTarget()
+	target.failEvery = 4
+	w := testWorkload(t, "1KiB")
+	w.ReadRatio = 0
+	w.Keep = true
+
+	report, err := NewRunner(target, nil).Run(context.Background(), w)
+	require.NoError(t, err)
+	assert.Equal(t, int64(50), report.Write.Errors)
+	assert.Equal(t, 0.25, report.Write.ErrorRate)
+	assert.Equal(t, []string{"disk full"}, report.Write.ErrorSamples)
+	assert.Len(t, target.objects, 150)
+}
+
+func TestRunStopsAfterDuration(t *testing.T) {
+	w := testWorkload(t, "1KiB")
+	w.Ops = 0
+	w.Duration = 50 * time.Millisecond
+
+	start := time.Now()
+	report, err := NewRunner(newMemTarget(), nil).Run(context.Background(), w)
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.Positive(t, report.Total.Ops)
+	assert.Zero(t, report.Total.Errors)
+}
+
+func TestPrefillFailureStopsRun(t *testing.T) {
+	target := newMemTarget()
+	target.failEvery = 1
+	_, err := NewRunner(target, nil).Run(context.Background(), testWorkload(t, "1KiB"))
+	assert.ErrorContains(t, err, "prefill")
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for n := 1; n <= 100; n++ {
+		sorted = append(sorted, time.Duration(n)*time.Millisecond)
+	}
+	assert.Equal(t, 50*time.Millisecond, percentile(sorted, 50))
+	assert.Equal(t, 99*time.Millisecond, percentile(sorted, 99))
+	assert.Equal(t, 100*time.Millisecond, percentile(sorted, 99.9))
+	assert.Equal(t, time.Millisecond, percentile(sorted[:1], 50))
+}
+
+func TestReportRoundTripAndCompare(t *testing.T) {
+	report, err := NewRunner(newMemTarget(), nil).Run(context.Background(), testWorkload(t, "1KiB"))
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	require.NoError(t, report.WriteFile(path))
+	loaded, err := LoadReport(path)
+	require.NoError(t, err)
+	assert.Equal(t, report.Total, loaded.Total)
+	assert.Empty(t, Compare(loaded, report, 0.1))
+
+	data, err := json.Marshal(report)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"sizes":"1KiB"`)
+
+	baseline := &Report{Total: OpStats{Ops: 100, OpsPerSec: 1000, Latency: Latency{P50: 2, P99: 10}}}
+	current := &Report{Total: OpStats{Ops: 100, OpsPerSec: 850, ErrorRate: 0.02, Latency: Latency{P50: 2.1, P99: 15}}}
+	var metrics []string
+	for _, r := range Compare(baseline, current, 0.1) {
+		metrics = append(metrics, r.Metric)
+	}
+	assert.Equal(t, []string{"total.ops_per_sec", "total.latency_ms.p99", "total.error_rate"}, metrics)
+	// A looser tolerance still catches errors on a clean baseline
+	regressions := Compare(baseline, current, 0.5)
+	require.Len(t, regressions, 1)
+	assert.Equal(t, "total.error_rate", regressions[0].Metric)
+}
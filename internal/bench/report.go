@@ -0,0 +1,231 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxErrorSamples bounds the distinct error messages kept per operation
+const maxErrorSamples = 5
+
+// Report is the outcome of a benchmark run
+type Report struct {
+	Workload  Workload  `json:"workload"`
+	StartedAt time.Time `json:"started_at"`
+	// ElapsedSeconds is the measured time, without prefill and cleanup
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	Total          OpStats `json:"total"`
+	Read           OpStats `json:"read"`
+	Write          OpStats `json:"write"`
+}
+
+// OpStats summarizes the operations of one kind, or of all kinds
+type OpStats struct {
+	Ops       int64   `json:"ops"`
+	Errors    int64   `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	Bytes     int64   `json:"bytes"`
+	OpsPerSec float64 `json:"ops_per_sec"`
+	// MBPerSec counts megabytes of 10^6 bytes
+	MBPerSec float64 `json:"mb_per_sec"`
+	// Latency covers successful operations only
+	Latency      Latency  `json:"latency_ms"`
+	ErrorSamples []string `json:"error_samples,omitempty"`
+}
+
+// Latency are latency percentiles in milliseconds
+type Latency struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p99_9"`
+	Max  float64 `json:"max"`
+}
+
+// recorder collects the outcome of operations of one kind
+type recorder struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	bytes     int64
+	errors    int64
+	samples   []string
+}
+
+func newRecorder() *recorder {
+	return &recorder{}
+}
+
+func (r *recorder) record(latency time.Duration, size int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.errors++
+		if msg := err.Error(); len(r.samples) < maxErrorSamples && !slices.Contains(r.samples, msg) {
+			r.samples = append(r.samples, msg)
+		}
+		return
+	}
+	r.latencies = append(r.latencies, latency)
+	r.bytes += size
+}
+
+func newReport(w Workload, started time.Time, elapsed time.Duration, results map[Op]*recorder) *Report {
+	read, write := results[OpRead], results[OpWrite]
+	all := &recorder{
+		latencies: append(slices.Clone(read.latencies), write.latencies...),
+		bytes:     read.bytes + write.bytes,
+		errors:    read.errors + write.errors,
+	}
+	for _, msg := range append(slices.Clone(read.samples), write.samples...) {
+		if len(all.samples) < maxErrorSamples && !slices.Contains(all.samples, msg) {
+			all.samples = append(all.samples, msg)
+		}
+	}
+	return &Report{
+		Workload:       w,
+		StartedAt:      started.UTC(),
+		ElapsedSeconds: round(elapsed.Seconds()),
+		Total:          all.stats(elapsed),
+		Read:           read.stats(elapsed),
+		Write:          write.stats(elapsed),
+	}
+}
+
+func (r *recorder) stats(elapsed time.Duration) OpStats {
+	ok := int64(len(r.latencies))
+	s := OpStats{
+		Ops:          ok + r.errors,
+		Errors:       r.errors,
+		Bytes:        r.bytes,
+		ErrorSamples: r.samples,
+	}
+	if s.Ops > 0 {
+		s.ErrorRate = round(float64(r.errors) / float64(s.Ops))
+	}
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		s.OpsPerSec = round(float64(s.Ops) / seconds)
+		s.MBPerSec = round(float64(r.bytes) / 1e6 / seconds)
+	}
+	if ok == 0 {
+		return s
+	}
+
+	sorted := slices.Clone(r.latencies)
+	slices.Sort(sorted)
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	s.Latency = Latency{
+		Mean: ms(sum / time.Duration(ok)),
+		P50:  ms(percentile(sorted, 50)),
+		P90:  ms(percentile(sorted, 90)),
+		P95:  ms(percentile(sorted, 95)),
+		P99:  ms(percentile(sorted, 99)),
+		P999: ms(percentile(sorted, 99.9)),
+		Max:  ms(sorted[len(sorted)-1]),
+	}
+	return s
+}
+
+// percentile returns the nearest-rank percentile p of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+func ms(d time.Duration) float64 {
+	return round(float64(d) / float64(time.Millisecond))
+}
+
+// round keeps reports readable: three decimals is below the resolution
+// that matters for any of the figures
+func round(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// WriteFile writes the report as indented JSON
+func (r *Report) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// LoadReport reads a report written by WriteFile
+func LoadReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// Only the figures are compared, so the workload is not read back
+	var report struct {
+		Report
+		Workload json.RawMessage `json:"workload"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("bench: failed to parse %s: %w", path, err)
+	}
+	return &report.Report, nil
+}
+
+// Regression is a figure that got worse than a baseline allows
+type Regression struct {
+	Metric   string  `json:"metric"`
+	Baseline float64 `json:"baseline"`
+	Current  float64 `json:"current"`
+	// Change is relative to the baseline, 0.25 being 25% worse, except
+	// for error rates, where it is the rise in the rate
+	Change float64 `json:"change"`
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s: %v -> %v (%+.1f%%)", r.Metric, r.Baseline, r.Current, r.Change*100)
+}
+
+// Compare returns the figures of the report that are worse than those of
+// the baseline by more than tolerance, a fraction such as 0.1 for 10%.
+// Throughput must not drop and p50 and p99 latencies must not rise; the
+// error rate may rise by tolerance of itself, or by a tenth of a percent
+// from a clean baseline.
+func Compare(baseline, current *Report, tolerance float64) []Regression {
+	var regressions []Regression
+	lower := func(metric string, base, cur float64) {
+		if base > 0 && cur < base*(1-tolerance) {
+			regressions = append(regressions, Regression{metric, base, cur, round((base - cur) / base)})
+		}
+	}
+	higher := func(metric string, base, cur float64) {
+		if base > 0 && cur > base*(1+tolerance) {
+			regressions = append(regressions, Regression{metric, base, cur, round((cur - base) / base)})
+		}
+	}
+
+	for _, op := range []struct {
+		name      string
+		base, cur OpStats
+	}{
+		{"total", baseline.Total, current.Total},
+		{"read", baseline.Read, current.Read},
+		{"write", baseline.Write, current.Write},
+	} {
+		if op.base.Ops == 0 || op.cur.Ops == 0 {
+			continue
+		}
+		lower(op.name+".ops_per_sec", op.base.OpsPerSec, op.cur.OpsPerSec)
+		higher(op.name+".latency_ms.p50", op.base.Latency.P50, op.cur.Latency.P50)
+		higher(op.name+".latency_ms.p99", op.base.Latency.P99, op.cur.Latency.P99)
+		if op.cur.ErrorRate > op.base.ErrorRate+max(op.base.ErrorRate*tolerance, 0.001) {
+			regressions = append(regressions, Regression{op.name + ".error_rate", op.base.ErrorRate, op.cur.ErrorRate, round(op.cur.ErrorRate - op.base.ErrorRate)})
+		}
+	}
+	return regressions
+}
@@ -0,0 +1,256 @@
+package bench
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultSizes is the object size distribution of a workload without one
+	DefaultSizes = "4KiB:70,64KiB:20,1MiB:10"
+	// DefaultPrefill is the number of objects written before a workload
+	// with reads starts, so the first reads have something to fetch
+	DefaultPrefill = 16
+	// MaxObjectSize bounds the objects of a workload; the payload of the
+	// largest one is held in memory
+	MaxObjectSize = 1 << 30
+)
+
+// Workload describes the operations a benchmark runs
+type Workload struct {
+	// Duration stops the run after this long; Ops stops it after this many
+	// operations. The first limit reached wins.
+	Duration time.Duration
+	Ops      int64
+	// Concurrency is the number of operations in flight
+	Concurrency int
+	// ReadRatio is the share of operations, from 0 to 1, that read an
+	// object; the others write one
+	ReadRatio float64
+	Sizes     Sizes
+	// Prefix starts the name of every object written
+	Prefix string
+	// Prefill objects are written before the clock starts when the
+	// workload reads
+	Prefill int
+	// Keep leaves the objects written in place instead of deleting them
+	// when the run ends
+	Keep bool
+	// Seed makes the operation mix and sizes reproducible; 0 picks a
+	// random seed
+	Seed uint64
+}
+
+// DefaultWorkload returns a 30 second workload of 80% reads over 8
+// connections
+func DefaultWorkload() Workload {
+	sizes, _ := ParseSizes(DefaultSizes)
+	return Workload{
+		Duration:    30 * time.Second,
+		Concurrency: 8,
+		ReadRatio:   0.8,
+		Sizes:       sizes,
+		Prefix:      "bench/",
+		Prefill:     DefaultPrefill,
+	}
+}
+
+// RegisterFlags adds flags for the fields of the workload to fs, with the
+// current values as defaults
+func (w *Workload) RegisterFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&w.Duration, "duration", w.Duration, "How long to run; 0 runs until -ops are done")
+	fs.Int64Var(&w.Ops, "ops", w.Ops, "Number of operations to run; 0 runs for -duration")
+	fs.IntVar(&w.Concurrency, "concurrency", w.Concurrency, "Number of operations in flight")
+	fs.Float64Var(&w.ReadRatio, "read-ratio", w.ReadRatio, "Share of reads, from 0 to 1")
+	fs.Var(&w.Sizes, "sizes", "Object sizes: 4KiB, a range 4KiB-1MiB or weights 4KiB:70,1MiB:30")
+	fs.StringVar(&w.Prefix, "prefix", w.Prefix, "Name prefix of the objects written")
+	fs.IntVar(&w.Prefill, "prefill", w.Prefill, "Objects written before a workload with reads starts")
+	fs.BoolVar(&w.Keep, "keep", w.Keep, "Keep the objects written instead of deleting them")
+	fs.Uint64Var(&w.Seed, "seed", w.Seed, "Seed of the operation mix; 0 picks one")
+}
+
+// Validate checks the workload
+func (w *Workload) Validate() error {
+	if w.Duration <= 0 && w.Ops <= 0 {
+		return errors.New("bench: workload needs a duration or an operation count")
+	}
+	if w.Duration < 0 || w.Ops < 0 {
+		return errors.New("bench: duration and operation count cannot be negative")
+	}
+	if w.Concurrency < 1 {
+		return errors.New("bench: concurrency must be at least 1")
+	}
+	if w.ReadRatio < 0 || w.ReadRatio > 1 {
+		return fmt.Errorf("bench: read ratio %v is not between 0 and 1", w.ReadRatio)
+	}
+	if len(w.Sizes.classes) == 0 {
+		return errors.New("bench: workload has no object sizes")
+	}
+	if w.Prefill < 0 {
+		return errors.New("bench: prefill cannot be negative")
+	}
+	return nil
+}
+
+// MarshalJSON records the workload in reports with readable durations and
+// sizes
+func (w Workload) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Duration    string  `json:"duration,omitempty"`
+		Ops         int64   `json:"ops,omitempty"`
+		Concurrency int     `json:"concurrency"`
+		ReadRatio   float64 `json:"read_ratio"`
+		Sizes       string  `json:"sizes"`
+		Prefill     int     `json:"prefill,omitempty"`
+		Seed        uint64  `json:"seed"`
+	}{
+		Duration:    durationString(w.Duration),
+		Ops:         w.Ops,
+		Concurrency: w.Concurrency,
+		ReadRatio:   w.ReadRatio,
+		Sizes:       w.Sizes.String(),
+		Prefill:     w.Prefill,
+		Seed:        w.Seed,
+	})
+}
+
+func durationString(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// Sizes is a distribution of object sizes: a fixed size, a uniform range
+// or sizes and ranges picked by weight
+type Sizes struct {
+	classes []sizeClass
+	total   int
+	spec    string
+}
+
+type sizeClass struct {
+	min, max int64
+	weight   int
+}
+
+// ParseSizes parses a size distribution such as "4KiB", "1KiB-1MiB" or
+// "4KiB:70,64KiB-1MiB:30". Units are B, KB, MB and GB in powers of 1000,
+// or KiB, MiB and GiB in powers of 1024.
+func ParseSizes(spec string) (Sizes, error) {
+	var s Sizes
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		class := sizeClass{weight: 1}
+		sizeSpec, weight, hasWeight := strings.Cut(part, ":")
+		if hasWeight {
+			w, err := strconv.Atoi(strings.TrimSpace(weight))
+			if err != nil || w < 1 {
+				return Sizes{}, fmt.Errorf("bench: invalid weight in %q", part)
+			}
+			class.weight = w
+		}
+		low, high, isRange := strings.Cut(sizeSpec, "-")
+		var err error
+		if class.min, err = ParseSize(low); err != nil {
+			return Sizes{}, err
+		}
+		class.max = class.min
+		if isRange {
+			if class.max, err = ParseSize(high); err != nil {
+				return Sizes{}, err
+			}
+			if class.max < class.min {
+				return Sizes{}, fmt.Errorf("bench: empty size range %q", sizeSpec)
+			}
+		}
+		if class.max > MaxObjectSize {
+			return Sizes{}, fmt.Errorf("bench: size %q is over the 1GiB limit", sizeSpec)
+		}
+		s.classes = append(s.classes, class)
+		s.total += class.weight
+	}
+	if len(s.classes) == 0 {
+		return Sizes{}, fmt.Errorf("bench: no sizes in %q", spec)
+	}
+	s.spec = spec
+	return s, nil
+}
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	// Longest suffixes first, so KiB is not read as a bare B
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// ParseSize parses a byte count with an optional unit, such as 512,
+// 64KiB or 10MB
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(unit.suffix)) {
+			factor = unit.factor
+			s = strings.TrimSpace(s[:len(s)-len(unit.suffix)])
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bench: invalid size %q", s)
+	}
+	return n * factor, nil
+}
+
+// String returns the distribution as it was parsed
+func (s *Sizes) String() string {
+	return s.spec
+}
+
+// Set implements flag.Value
+func (s *Sizes) Set(spec string) error {
+	parsed, err := ParseSizes(spec)
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
+
+// Max returns the largest size of the distribution
+func (s *Sizes) Max() int64 {
+	var largest int64
+	for _, c := range s.classes {
+		largest = max(largest, c.max)
+	}
+	return largest
+}
+
+// pick draws a size
+func (s *Sizes) pick(rng *rand.Rand) int64 {
+	n := rng.IntN(s.total)
+	for _, c := range s.classes {
+		if n < c.weight {
+			if c.max == c.min {
+				return c.min
+			}
+			return c.min + rng.Int64N(c.max-c.min+1)
+		}
+		n -= c.weight
+	}
+	return s.classes[len(s.classes)-1].max
+}
@@ -0,0 +1,268 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Eviction policies of a ByteCache
+const (
+	// PolicyLRU evicts the entry used least recently
+	PolicyLRU = "lru"
+	// PolicyARC balances entries used once against entries used again
+	// (adaptive replacement), so a scan of cold objects does not flush the
+	// hot ones
+	PolicyARC = "arc"
+)
+
+// ByteCacheStats holds the counters of a ByteCache
+type ByteCacheStats struct {
+	Policy    string  `json:"policy"`
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	Evictions int64   `json:"evictions"`
+	Entries   int     `json:"entries"`
+	Bytes     int64   `json:"bytes"`
+	Capacity  int64   `json:"capacity"`
+	HitRatio  float64 `json:"hit_ratio"`
+}
+
+// ByteCache keeps values up to a total size in bytes, evicting by LRU or
+// ARC. Values are shared with callers, who must not modify them.
+type ByteCache[T any] struct {
+	mu       sync.Mutex
+	policy   string
+	capacity int64
+	ttl      time.Duration
+	entries  map[string]*list.Element
+
+	// recent holds the entries used once, frequent those used again; LRU
+	// only uses recent. The ghost lists remember the keys, not values, ARC
+	// evicted from each, and target is the share of the capacity ARC
+	// currently gives recent.
+	recent, frequent           *list.List
+	recentGhost, frequentGhost *list.List
+	recentBytes, frequentBytes int64
+	recentGhostBytes           int64
+	frequentGhostBytes         int64
+	target                     int64
+
+	hits, misses, evictions int64
+}
+
+// byteEntry is an entry of a ByteCache, resident or ghost
+type byteEntry[T any] struct {
+	key    string
+	value  T
+	size   int64
+	stored time.Time
+	list   *list.List
+}
+
+// NewByteCache creates a cache of capacity bytes evicting by policy, LRU
+// when empty. Entries older than ttl are misses; zero keeps them until
+// they are evicted.
+func NewByteCache[T any](capacity int64, policy string, ttl time.Duration) (*ByteCache[T], error) {
+	switch policy {
+	case "":
+		policy = PolicyLRU
+	case PolicyLRU, PolicyARC:
+	default:
+		return nil, fmt.Errorf("unknown cache policy %q, want %s or %s", policy, PolicyLRU, PolicyARC)
+	}
+	if capacity <= 0 {
+		return nil, fmt.Errorf("cache capacity must be positive")
+	}
+	return &ByteCache[T]{
+		policy:        policy,
+		capacity:      capacity,
+		ttl:           ttl,
+		entries:       make(map[string]*list.Element),
+		recent:        list.New(),
+		frequent:      list.New(),
+		recentGhost:   list.New(),
+		frequentGhost: list.New(),
+	}, nil
+}
+
+// Get returns the value cached for key
+func (c *ByteCache[T]) Get(key string) (T, bool) {
+	var zero T
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok || c.ghost(el) {
+		c.misses++
+		return zero, false
+	}
+	e := el.Value.(*byteEntry[T])
+	if c.ttl > 0 && time.Since(e.stored) > c.ttl {
+		c.removeLocked(el)
+		c.misses++
+		return zero, false
+	}
+	c.hits++
+	if c.policy == PolicyARC {
+		c.moveLocked(el, c.frequent)
+	} else {
+		c.recent.MoveToFront(el)
+	}
+	return e.value, true
+}
+
+// Put caches value, of size bytes, under key, replacing what was cached
+// there. Values larger than the whole cache are not kept.
+func (c *ByteCache[T]) Put(key string, value T, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if size > c.capacity {
+		if el, ok := c.entries[key]; ok {
+			c.removeLocked(el)
+		}
+		return
+	}
+
+	into := c.recent
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*byteEntry[T])
+		switch e.list {
+		case c.recentGhost:
+			// Evicted from recent too early: give recent more room
+			c.target = min(c.capacity, c.target+max(size, c.ratio(c.frequentGhostBytes, c.recentGhostBytes)*size))
+			into = c.frequent
+		case c.frequentGhost:
+			// Evicted from frequent too early: give frequent more room
+			c.target = max(0, c.target-max(size, c.ratio(c.recentGhostBytes, c.frequentGhostBytes)*size))
+			into = c.frequent
+		default:
+			if c.policy == PolicyARC {
+				into = c.frequent
+			}
+		}
+		c.removeLocked(el)
+	}
+
+	c.makeRoomLocked(size, into == c.frequent)
+	e := &byteEntry[T]{key: key, value: value, size: size, stored: time.Now()}
+	c.pushLocked(e, into)
+	c.trimGhostsLocked()
+}
+
+// Remove drops the value cached for key
+func (c *ByteCache[T]) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.removeLocked(el)
+	}
+}
+
+// Stats returns the counters of the cache
+func (c *ByteCache[T]) Stats() ByteCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := ByteCacheStats{
+		Policy:    c.policy,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   c.recent.Len() + c.frequent.Len(),
+		Bytes:     c.recentBytes + c.frequentBytes,
+		Capacity:  c.capacity,
+	}
+	if total := c.hits + c.misses; total > 0 {
+		stats.HitRatio = float64(c.hits) / float64(total)
+	}
+	return stats
+}
+
+// ratio returns a/b, at least 1
+func (c *ByteCache[T]) ratio(a, b int64) int64 {
+	if b == 0 {
+		return 1
+	}
+	return max(1, a/b)
+}
+
+func (c *ByteCache[T]) ghost(el *list.Element) bool {
+	l := el.Value.(*byteEntry[T]).list
+	return l == c.recentGhost || l == c.frequentGhost
+}
+
+// makeRoomLocked evicts resident entries until size more bytes fit. ARC
+// takes them from recent while it is above its target and from frequent
+// otherwise, remembering their keys in the ghost lists.
+func (c *ByteCache[T]) makeRoomLocked(size int64, toFrequent bool) {
+	for c.recentBytes+c.frequentBytes+size > c.capacity {
+		fromRecent := c.frequent.Len() == 0 ||
+			(c.recent.Len() > 0 && (c.recentBytes > c.target || (toFrequent && c.recentBytes == c.target)))
+		from, ghost := c.frequent, c.frequentGhost
+		if fromRecent {
+			from, ghost = c.recent, c.recentGhost
+		}
+		el := from.Back()
+		if el == nil {
+			return
+		}
+		c.evictions++
+		if c.policy != PolicyARC {
+			c.removeLocked(el)
+			continue
+		}
+		e := el.Value.(*byteEntry[T])
+		c.moveLocked(el, ghost)
+		var zero T
+		e.value = zero
+	}
+}
+
+// trimGhostsLocked bounds the ghost lists: recent and its ghosts hold at
+// most the capacity, and everything at most twice it
+func (c *ByteCache[T]) trimGhostsLocked() {
+	for c.recentBytes+c.recentGhostBytes > c.capacity && c.recentGhost.Len() > 0 {
+		c.removeLocked(c.recentGhost.Back())
+	}
+	for c.recentBytes+c.frequentBytes+c.recentGhostBytes+c.frequentGhostBytes > 2*c.capacity && c.frequentGhost.Len() > 0 {
+		c.removeLocked(c.frequentGhost.Back())
+	}
+}
+
+// moveLocked moves an entry to the front of another list
+func (c *ByteCache[T]) moveLocked(el *list.Element, to *list.List) {
+	e := el.Value.(*byteEntry[T])
+	if e.list == to {
+		to.MoveToFront(el)
+		return
+	}
+	c.removeLocked(el)
+	c.pushLocked(e, to)
+}
+
+func (c *ByteCache[T]) pushLocked(e *byteEntry[T], to *list.List) {
+	e.list = to
+	c.entries[e.key] = to.PushFront(e)
+	*c.bytesOf(to) += e.size
+}
+
+func (c *ByteCache[T]) removeLocked(el *list.Element) {
+	e := el.Value.(*byteEntry[T])
+	e.list.Remove(el)
+	*c.bytesOf(e.list) -= e.size
+	delete(c.entries, e.key)
+}
+
+// bytesOf returns the byte counter of a list
+func (c *ByteCache[T]) bytesOf(l *list.List) *int64 {
+	switch l {
+	case c.recent:
+		return &c.recentBytes
+	case c.frequent:
+		return &c.frequentBytes
+	case c.recentGhost:
+		return &c.recentGhostBytes
+	default:
+		return &c.frequentGhostBytes
+	}
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestByteCacheLRU(t *testing.T) {
+	c, err := NewByteCache[[]byte](10, PolicyLRU, 0)
+	require.NoError(t, err)
+
+	c.Put("a", []byte("aaaa"), 4)
+	c.Put("b", []byte("bbbb"), 4)
+	_, ok := c.Get("a")
+	require.True(t, ok)
+	// b is the least recently used and makes room for c
+	c.Put("c", []byte("cccc"), 4)
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "aaaa", string(v))
+
+	c.Remove("a")
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+
+	// Values larger than the cache are not kept
+	c.Put("big", make([]byte, 11), 11)
+	_, ok = c.Get("big")
+	assert.False(t, ok)
+
+	stats := c.Stats()
+	assert.Equal(t, int64(2), stats.Hits)
+	assert.Equal(t, int64(3), stats.Misses)
+	assert.Equal(t, int64(1), stats.Evictions)
+	assert.Equal(t, 1, stats.Entries)
+	assert.Equal(t, int64(4), stats.Bytes)
+	assert.InDelta(t, 0.4, stats.HitRatio, 0.001)
+}
+
+func TestByteCacheARCResistsScans(t *testing.T) {
+	for _, policy := range []string{PolicyLRU, PolicyARC} {
+		c, err := NewByteCache[[]byte](8, policy, 0)
+		require.NoError(t, err)
+		c.Put("hot1", []byte("11"), 2)
+		c.Put("hot2", []byte("22"), 2)
+		c.Get("hot1")
+		c.Get("hot2")
+
+		// A scan of objects read once
+		for i := range 20 {
+			c.Put(fmt.Sprintf("cold%d", i), []byte("cc"), 2)
+		}
+		_, hot1 := c.Get("hot1")
+		_, hot2 := c.Get("hot2")
+		if policy == PolicyARC {
+			assert.True(t, hot1 && hot2, "ARC keeps the hot objects")
+		} else {
+			assert.False(t, hot1 || hot2, "LRU flushes the hot objects")
+		}
+		assert.LessOrEqual(t, c.Stats().Bytes, int64(8))
+	}
+}
+
+func TestByteCacheARCAdapts(t *testing.T) {
+	c, err := NewByteCache[[]byte](4, PolicyARC, 0)
+	require.NoError(t, err)
+	for round := range 3 {
+		for i := range 6 {
+			key := fmt.Sprintf("k%d", i)
+			if _, ok := c.Get(key); !ok {
+				c.Put(key, []byte("x"), 1)
+			}
+		}
+		stats := c.Stats()
+		assert.LessOrEqual(t, stats.Bytes, int64(4), "round %d", round)
+	}
+	assert.Equal(t, 4, c.Stats().Entries)
+}
+
+func TestByteCacheTTL(t *testing.T) {
+	c, err := NewByteCache[[]byte](10, PolicyLRU, time.Millisecond)
+	require.NoError(t, err)
+	c.Put("a", []byte("a"), 1)
+	time.Sleep(5 * time.Millisecond)
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.Stats().Entries)
+}
+
+func TestNewByteCacheRejectsBadConfig(t *testing.T) {
+	_, err := NewByteCache[[]byte](10, "lfu", 0)
+	assert.Error(t, err)
+	_, err = NewByteCache[[]byte](0, PolicyARC, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,63 @@
+package analytics
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+)
+
+// accessColors colour the datasets of access charts
+var accessColors = []string{"#3498db", "#e74c3c", "#2ecc71", "#f39c12", "#9b59b6"}
+
+// AccessChart turns the access rollups of a node into a line chart of the
+// accesses per bucket, with a dataset for each of the series keys, tenants
+// or peers accessed most. Buckets without accesses are charted as zero.
+func AccessChart(rollups *client.AccessRollups, series int) *ChartData {
+	chart := &ChartData{Labels: []string{}, Datasets: []*Dataset{}, Type: "line"}
+	if len(rollups.Rollups) == 0 {
+		return chart
+	}
+
+	step := time.Duration(rollups.BucketSeconds) * time.Second
+	if step <= 0 {
+		step = time.Hour
+	}
+	first, last := rollups.Rollups[0].Start, rollups.Rollups[0].Start
+	totals := make(map[string]int64)
+	for _, r := range rollups.Rollups {
+		if r.Start.Before(first) {
+			first = r.Start
+		}
+		if r.Start.After(last) {
+			last = r.Start
+		}
+		totals[r.Value] += r.Total()
+	}
+	buckets := int(last.Sub(first)/step) + 1
+	for i := 0; i < buckets; i++ {
+		chart.Labels = append(chart.Labels, first.Add(time.Duration(i)*step).Local().Format("01-02 15:04"))
+	}
+
+	values := make([]string, 0, len(totals))
+	for value := range totals {
+		values = append(values, value)
+	}
+	slices.SortFunc(values, func(a, b string) int { return cmp.Or(cmp.Compare(totals[b], totals[a]), cmp.Compare(a, b)) })
+	if series > 0 && len(values) > series {
+		values = values[:series]
+	}
+	datasets := make(map[string]*Dataset, len(values))
+	for i, value := range values {
+		color := accessColors[i%len(accessColors)]
+		datasets[value] = &Dataset{Label: value, Data: make([]float64, buckets), BackgroundColor: color, BorderColor: color}
+		chart.Datasets = append(chart.Datasets, datasets[value])
+	}
+	for _, r := range rollups.Rollups {
+		if d, ok := datasets[r.Value]; ok {
+			d.Data[int(r.Start.Sub(first)/step)] += float64(r.Total())
+		}
+	}
+	return chart
+}
@@ -279,6 +279,32 @@ func (dm *DashboardManager) AddWidget(ctx context.Context, dashboardID, widgetTy
 	return widget, nil
 }
 
+// SetWidgetData replaces the data a widget shows
+func (dm *DashboardManager) SetWidgetData(ctx context.Context, widgetID string, data map[string]interface{}) error {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	widget, exists := dm.widgets[widgetID]
+	if !exists {
+		return fmt.Errorf("widget not found: %s", widgetID)
+	}
+	widget.Data = data
+	widget.UpdatedAt = time.Now()
+	// Dashboards hold their own copies of the widgets loaded from disk
+	for _, dashboard := range dm.dashboards {
+		for i, w := range dashboard.Widgets {
+			if w.ID == widgetID {
+				dashboard.Widgets[i] = widget
+				dashboard.UpdatedAt = widget.UpdatedAt
+			}
+		}
+	}
+
+	_ = dm.saveDashboards()
+	_ = dm.saveWidgets()
+	return nil
+}
+
 // CreateMetric creates a new metric
 func (dm *DashboardManager) CreateMetric(ctx context.Context, name, unit string, value float64, thresholds map[string]float64) (*Metric, error) {
 	dm.mu.Lock()
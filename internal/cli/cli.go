@@ -1,12 +1,26 @@
 package cli
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"sync"
 )
 
+// Exit codes for non-interactive use
+const (
+	ExitOK      = 0
+	ExitFailure = 1
+	// ExitUsage is returned for unknown commands and invalid arguments
+	ExitUsage = 2
+)
+
+// ErrUnknownCommand is returned when no command has the given name
+var ErrUnknownCommand = errors.New("unknown command")
+
 // Command represents a CLI command
 type Command interface {
 	Name() string
@@ -42,7 +56,7 @@ func (c *CLI) Execute(ctx context.Context, command string, args []string) error
 	c.mu.RUnlock()
 
 	if !exists {
-		return fmt.Errorf("unknown command: %s. Type 'help' for available commands", command)
+		return fmt.Errorf("%w: %s. Type 'help' for available commands", ErrUnknownCommand, command)
 	}
 
 	return cmd.Execute(ctx, args)
@@ -98,3 +112,115 @@ func (c *CLI) GetCompletions(input string) []string {
 
 	return completions
 }
+
+// ExitCode maps a command error to a process exit code. Commands report bad
+// arguments with errors starting "usage:".
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var scriptErr *ScriptError
+	if errors.As(err, &scriptErr) {
+		err = scriptErr.Err
+	}
+	if errors.Is(err, ErrUnknownCommand) || strings.HasPrefix(err.Error(), "usage:") {
+		return ExitUsage
+	}
+	return ExitFailure
+}
+
+// SplitArgs splits a command line into words. Single and double quotes
+// group words containing spaces and a backslash escapes the next character.
+func SplitArgs(line string) ([]string, error) {
+	var (
+		args    []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+// ExecuteLine splits a command line and executes it; blank lines and
+// # comments do nothing
+func (c *CLI) ExecuteLine(ctx context.Context, line string) error {
+	if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return nil
+	}
+	args, err := SplitArgs(line)
+	if err != nil {
+		return fmt.Errorf("usage: %w", err)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return c.Execute(ctx, args[0], args[1:])
+}
+
+// RunScript executes one command per line of r, after passing each line
+// through expand (e.g. alias expansion) when it is not nil. It stops at the
+// first failing command and returns its error prefixed with the line number.
+func (c *CLI) RunScript(ctx context.Context, r io.Reader, expand func(string) string) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if expand != nil {
+			line = expand(line)
+		}
+		if err := c.ExecuteLine(ctx, line); err != nil {
+			return &ScriptError{Line: lineNo, Err: err}
+		}
+	}
+	return scanner.Err()
+}
+
+// ScriptError is a command failure in a script
+type ScriptError struct {
+	Line int
+	Err  error
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
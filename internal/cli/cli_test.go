@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordCommand records the arguments of each call and fails on "fail"
+type recordCommand struct {
+	calls [][]string
+}
+
+func (r *recordCommand) Name() string        { return "echo" }
+func (r *recordCommand) Description() string { return "records its arguments" }
+func (r *recordCommand) Usage() string       { return "echo [args...]" }
+
+func (r *recordCommand) Execute(ctx context.Context, args []string) error {
+	r.calls = append(r.calls, args)
+	if len(args) > 0 && args[0] == "fail" {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"store file.txt", []string{"store", "file.txt"}},
+		{"  list \t --tag  a ", []string{"list", "--tag", "a"}},
+		{`store "my file.txt"`, []string{"store", "my file.txt"}},
+		{`tag 'it''s' x`, []string{"tag", "its", "x"}},
+		{`store my\ file.txt`, []string{"store", "my file.txt"}},
+		{`attr "a \"b\""`, []string{"attr", `a "b"`}},
+		{`attr 'a\b'`, []string{"attr", `a\b`}},
+		{`set key ""`, []string{"set", "key", ""}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := SplitArgs(tt.line)
+		require.NoError(t, err, tt.line)
+		assert.Equal(t, tt.want, got, tt.line)
+	}
+
+	_, err := SplitArgs(`store "file.txt`)
+	assert.Error(t, err)
+	_, err = SplitArgs(`store file\`)
+	assert.Error(t, err)
+}
+
+func TestExitCode(t *testing.T) {
+	c := New()
+	unknown := c.Execute(context.Background(), "nope", nil)
+
+	assert.Equal(t, ExitOK, ExitCode(nil))
+	assert.Equal(t, ExitFailure, ExitCode(errors.New("connection refused")))
+	assert.Equal(t, ExitUsage, ExitCode(unknown))
+	assert.Equal(t, ExitUsage, ExitCode(errors.New("usage: store <file>")))
+	assert.Equal(t, ExitUsage, ExitCode(&ScriptError{Line: 3, Err: errors.New("usage: store <file>")}))
+	assert.Equal(t, ExitFailure, ExitCode(&ScriptError{Line: 3, Err: errors.New("failed")}))
+}
+
+func TestRunScript(t *testing.T) {
+	c := New()
+	cmd := &recordCommand{}
+	c.RegisterCommand("echo", cmd)
+
+	script := strings.Join([]string{
+		"# setup",
+		"",
+		`echo "a b" c`,
+		"e 1",
+		"echo fail",
+		"echo never",
+	}, "\n")
+	expand := func(line string) string {
+		if strings.HasPrefix(line, "e ") {
+			return "echo " + strings.TrimPrefix(line, "e ")
+		}
+		return line
+	}
+
+	err := c.RunScript(context.Background(), strings.NewReader(script), expand)
+
+	var scriptErr *ScriptError
+	require.ErrorAs(t, err, &scriptErr)
+	assert.Equal(t, 5, scriptErr.Line)
+	assert.Equal(t, "line 5: failed", err.Error())
+	assert.Equal(t, [][]string{{"a b", "c"}, {"1"}, {"fail"}}, cmd.calls)
+}
+
+func TestRunScriptUnknownCommand(t *testing.T) {
+	c := New()
+	err := c.RunScript(context.Background(), strings.NewReader("echo hi\n"), nil)
+
+	require.ErrorIs(t, err, ErrUnknownCommand)
+	assert.Equal(t, ExitUsage, ExitCode(err))
+}
@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -21,6 +25,7 @@ type Client struct {
 	config     *config.Config
 	httpClient *http.Client
 	baseURL    string
+	defaultURL string
 	authToken  string
 	connected  bool
 	retryCount int
@@ -34,6 +39,7 @@ func New(cfg *config.Config) *Client {
 			Timeout: 30 * time.Second,
 		},
 		baseURL:    cfg.ServerURL,
+		defaultURL: cfg.ServerURL,
 		authToken:  cfg.AuthToken,
 		connected:  false,
 		retryCount: 3,
@@ -45,6 +51,17 @@ func (c *Client) SetServerURL(url string) {
 	c.baseURL = url
 }
 
+// ServerURL returns the URL requests are sent to
+func (c *Client) ServerURL() string {
+	return c.baseURL
+}
+
+// ResetServerURL goes back to the server of the active connection after
+// SetServerURL
+func (c *Client) ResetServerURL() {
+	c.baseURL = c.defaultURL
+}
+
 // SetAuthToken sets the authentication token
 func (c *Client) SetAuthToken(token string) {
 	c.authToken = token
@@ -72,6 +89,11 @@ func (c *Client) Disconnect() {
 	c.connected = false
 }
 
+// SetTimeout sets the time limit of each request; zero means none
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.httpClient.Timeout = timeout
+}
+
 // SetRetryCount sets the number of retries for failed requests
 func (c *Client) SetRetryCount(count int) {
 	c.retryCount = count
@@ -150,13 +172,29 @@ func (c *Client) Delete(ctx context.Context, endpoint string) (*http.Response, e
 	return c.makeRequest(ctx, "DELETE", endpoint, nil)
 }
 
+// APIError is an error response from the server
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 response
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
 // ParseResponse parses an HTTP response into the target interface
 func (c *Client) ParseResponse(resp *http.Response, target interface{}) error {
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+		return &APIError{StatusCode: resp.StatusCode, Message: string(body)}
 	}
 
 	if target == nil {
@@ -173,12 +211,17 @@ func (c *Client) ParseResponse(resp *http.Response, target interface{}) error {
 
 // File operations
 type FileInfo struct {
-	ID        string    `json:"id"`
-	Key       string    `json:"key"`
-	Size      int64     `json:"size"`
-	Hash      string    `json:"hash"`
-	CreatedAt time.Time `json:"created_at"`
-	Owner     string    `json:"owner"`
+	ID          string            `json:"id"`
+	Key         string            `json:"key"`
+	Name        string            `json:"name,omitempty"`
+	Size        int64             `json:"size"`
+	ContentType string            `json:"content_type,omitempty"`
+	Hash        string            `json:"hash"`
+	MerkleRoot  string            `json:"merkle_root,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	Owner       string            `json:"owner"`
+	Tags        []string          `json:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
 }
 
 type FileListResponse struct {
@@ -188,6 +231,11 @@ type FileListResponse struct {
 
 // StoreFile stores a file with real upload functionality
 func (c *Client) StoreFile(ctx context.Context, filePath string) (*FileInfo, error) {
+	return c.StoreFileWithAttributes(ctx, filePath, nil, nil)
+}
+
+// StoreFileWithAttributes stores a file with tags and custom metadata attached
+func (c *Client) StoreFileWithAttributes(ctx context.Context, filePath string, tags []string, metadata map[string]string) (*FileInfo, error) {
 	// Check if file exists
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -195,36 +243,39 @@ func (c *Client) StoreFile(ctx context.Context, filePath string) (*FileInfo, err
 	}
 	defer func() { _ = file.Close() }()
 
-	// Get file info
-	_, err = file.Stat()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get file info: %w", err)
-	}
-
-	// Create multipart form
-	var buf bytes.Buffer
-	writer := multipart.NewWriter(&buf)
+	return c.UploadData(ctx, filepath.Base(filePath), "", file, tags, metadata)
+}
 
-	// Add file field
-	part, err := writer.CreateFormFile("file", filepath.Base(filePath))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create form file: %w", err)
-	}
+// UploadData stores the content read from r as a file called name. An
+// empty contentType uploads it as application/octet-stream.
+func (c *Client) UploadData(ctx context.Context, name, contentType string, r io.Reader, tags []string, metadata map[string]string) (*FileInfo, error) {
+	return c.UploadDataAt(ctx, "", name, contentType, r, tags, metadata)
+}
 
-	// Copy file content
-	_, err = io.Copy(part, file)
-	if err != nil {
-		return nil, fmt.Errorf("failed to copy file content: %w", err)
+// UploadDataAt stores the content read from r under path, replacing the
+// file stored there. A path ending in "/" places the file in that folder
+// under its name; an empty path lets the server pick a key.
+func (c *Client) UploadDataAt(ctx context.Context, path, name, contentType string, r io.Reader, tags []string, metadata map[string]string) (*FileInfo, error) {
+	if contentType == "" {
+		contentType = "application/octet-stream"
 	}
 
-	// Close writer
-	err = writer.Close()
-	if err != nil {
-		return nil, fmt.Errorf("failed to close writer: %w", err)
-	}
+	// Stream the multipart form so large files are never held in memory
+	body, bodyWriter := io.Pipe()
+	writer := multipart.NewWriter(bodyWriter)
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		bodyWriter.CloseWithError(writeUploadForm(writer, path, name, contentType, r, tags, metadata))
+	}()
+	// The caller owns r again once we return
+	defer func() {
+		_ = body.Close()
+		<-written
+	}()
 
 	// Create request
-	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/v1/files", &buf)
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/v1/files", body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -258,46 +309,80 @@ func (c *Client) StoreFile(ctx context.Context, filePath string) (*FileInfo, err
 	return &result, nil
 }
 
-// DownloadFile downloads a file to the specified path
-func (c *Client) DownloadFile(ctx context.Context, fileID, outputPath string) error {
-	// Get file info first
-	_, err := c.GetFile(ctx, fileID)
+// writeUploadForm writes the file part and attribute fields of an upload
+func writeUploadForm(writer *multipart.Writer, path, name, contentType string, r io.Reader, tags []string, metadata map[string]string) error {
+	// Add file field
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": "file", "filename": name}))
+	header.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(header)
 	if err != nil {
-		return fmt.Errorf("failed to get file info: %w", err)
+		return fmt.Errorf("failed to create form file: %w", err)
 	}
 
-	// Create output file
-	outFile, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+	// Copy file content
+	if _, err := io.Copy(part, r); err != nil {
+		return fmt.Errorf("failed to copy file content: %w", err)
 	}
-	defer func() { _ = outFile.Close() }()
 
-	// Download file
-	resp, err := c.Get(ctx, "/api/v1/files/"+fileID+"/download")
+	// Add attribute fields
+	if path != "" {
+		if err := writer.WriteField("path", path); err != nil {
+			return fmt.Errorf("failed to write path: %w", err)
+		}
+	}
+	if len(tags) > 0 {
+		if err := writer.WriteField("tags", strings.Join(tags, ",")); err != nil {
+			return fmt.Errorf("failed to write tags: %w", err)
+		}
+	}
+	if len(metadata) > 0 {
+		encoded, err := json.Marshal(metadata)
+		if err != nil {
+			return fmt.Errorf("failed to encode metadata: %w", err)
+		}
+		if err := writer.WriteField("metadata", string(encoded)); err != nil {
+			return fmt.Errorf("failed to write metadata: %w", err)
+		}
+	}
+
+	return writer.Close()
+}
+
+// DownloadContent streams the content of a file to w and returns the
+// number of bytes written
+func (c *Client) DownloadContent(ctx context.Context, key string, w io.Writer) (int64, error) {
+	resp, err := c.Get(ctx, "/api/v1/files/"+url.PathEscape(key)+"/content")
 	if err != nil {
-		return fmt.Errorf("failed to download file: %w", err)
+		return 0, fmt.Errorf("failed to download file: %w", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	// Check response
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("download failed %d: %s", resp.StatusCode, string(body))
+		return 0, fmt.Errorf("download failed %d: %s", resp.StatusCode, string(body))
 	}
+	return io.Copy(w, resp.Body)
+}
 
-	// Copy content to file
-	_, err = io.Copy(outFile, resp.Body)
+// DownloadFile downloads a file to the specified path
+func (c *Client) DownloadFile(ctx context.Context, fileID, outputPath string) error {
+	// Create output file
+	outFile, err := os.Create(outputPath)
 	if err != nil {
-		return fmt.Errorf("failed to save file: %w", err)
+		return fmt.Errorf("failed to create output file: %w", err)
 	}
+	defer func() { _ = outFile.Close() }()
 
-	return nil
+	if _, err := c.DownloadContent(ctx, fileID, outFile); err != nil {
+		return err
+	}
+	return outFile.Close()
 }
 
 // GetFile retrieves file information
 func (c *Client) GetFile(ctx context.Context, fileID string) (*FileInfo, error) {
-	resp, err := c.Get(ctx, "/api/v1/files/"+fileID)
+	resp, err := c.Get(ctx, "/api/v1/files/get?key="+url.QueryEscape(fileID))
 	if err != nil {
 		return nil, err
 	}
@@ -319,6 +404,190 @@ func (c *Client) ListFiles(ctx context.Context) (*FileListResponse, error) {
 	return &files, err
 }
 
+// FileAttributePatch describes changes to a file's tags and metadata
+type FileAttributePatch struct {
+	Set        map[string]string `json:"set,omitempty"`
+	Remove     []string          `json:"remove,omitempty"`
+	AddTags    []string          `json:"add_tags,omitempty"`
+	RemoveTags []string          `json:"remove_tags,omitempty"`
+}
+
+// PatchFileMetadata updates the tags and metadata of a file
+func (c *Client) PatchFileMetadata(ctx context.Context, fileID string, patch *FileAttributePatch) (*FileInfo, error) {
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.makeRequest(ctx, "PATCH", "/api/v1/files/"+url.PathEscape(fileID)+"/metadata", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var file FileInfo
+	err = c.ParseResponse(resp, &file)
+	return &file, err
+}
+
+// ObjectCopy copies or moves a file to another key on the server
+type ObjectCopy struct {
+	From      string            `json:"from"`
+	To        string            `json:"to"`
+	Tenant    string            `json:"tenant,omitempty"`
+	Overwrite bool              `json:"overwrite,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// CopyObject copies a file to another key, possibly of another tenant,
+// without downloading it
+func (c *Client) CopyObject(ctx context.Context, req *ObjectCopy) (*FileInfo, error) {
+	return c.transferObject(ctx, "copy", req)
+}
+
+// MoveObject moves a file to another key, possibly of another tenant,
+// without downloading it
+func (c *Client) MoveObject(ctx context.Context, req *ObjectCopy) (*FileInfo, error) {
+	return c.transferObject(ctx, "move", req)
+}
+
+func (c *Client) transferObject(ctx context.Context, op string, req *ObjectCopy) (*FileInfo, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Post(ctx, "/api/v1/files/"+op, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var file FileInfo
+	err = c.ParseResponse(resp, &file)
+	return &file, err
+}
+
+// ObjectCompose builds a file from other files, concatenated in order
+type ObjectCompose struct {
+	Sources       []string          `json:"sources"`
+	To            string            `json:"to"`
+	ContentType   string            `json:"content_type,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	Overwrite     bool              `json:"overwrite,omitempty"`
+	DeleteSources bool              `json:"delete_sources,omitempty"`
+}
+
+// ComposeObject joins files stored on the server into a new file
+func (c *Client) ComposeObject(ctx context.Context, req *ObjectCompose) (*FileInfo, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Post(ctx, "/api/v1/files/compose", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var file FileInfo
+	err = c.ParseResponse(resp, &file)
+	return &file, err
+}
+
+// FileReplica is one copy of a file
+type FileReplica struct {
+	Node         string    `json:"node"`
+	Addr         string    `json:"addr"`
+	Local        bool      `json:"local,omitempty"`
+	Status       string    `json:"status"`
+	Size         int64     `json:"size"`
+	LastVerified time.Time `json:"last_verified"`
+}
+
+// FileReplicas tells where the copies of a file are
+type FileReplicas struct {
+	Key               string        `json:"key"`
+	ReplicationFactor int           `json:"replication_factor"`
+	Healthy           int           `json:"healthy"`
+	Satisfied         bool          `json:"satisfied"`
+	Replicas          []FileReplica `json:"replicas"`
+	CheckedAt         time.Time     `json:"checked_at"`
+}
+
+// GetFileReplicas asks the node which peers hold a copy of a file
+func (c *Client) GetFileReplicas(ctx context.Context, key string) (*FileReplicas, error) {
+	resp, err := c.Get(ctx, "/api/v1/files/"+url.PathEscape(key)+"/replicas")
+	if err != nil {
+		return nil, err
+	}
+
+	var replicas FileReplicas
+	err = c.ParseResponse(resp, &replicas)
+	return &replicas, err
+}
+
+// Usage counts the files and bytes under a prefix or of a tenant
+type Usage struct {
+	Prefix       string `json:"prefix,omitempty"`
+	Tenant       string `json:"tenant,omitempty"`
+	Objects      int64  `json:"objects"`
+	Bytes        int64  `json:"bytes"`
+	Versions     int64  `json:"versions"`
+	VersionBytes int64  `json:"version_bytes"`
+}
+
+// GetUsage gets the usage under a directory prefix, first, and of the
+// directories up to depth levels below it
+func (c *Client) GetUsage(ctx context.Context, prefix string, depth int) ([]Usage, error) {
+	query := url.Values{}
+	query.Set("prefix", prefix)
+	query.Set("depth", fmt.Sprint(depth))
+	resp, err := c.Get(ctx, "/api/v1/files/usage?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Prefixes []Usage `json:"prefixes"`
+	}
+	err = c.ParseResponse(resp, &result)
+	return result.Prefixes, err
+}
+
+// GetTenantUsage gets the usage of every tenant
+func (c *Client) GetTenantUsage(ctx context.Context) ([]Usage, error) {
+	resp, err := c.Get(ctx, "/api/v1/files/usage/tenants")
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Tenants []Usage `json:"tenants"`
+	}
+	err = c.ParseResponse(resp, &result)
+	return result.Tenants, err
+}
+
+// SearchFiles lists files that carry all given tags and metadata pairs
+func (c *Client) SearchFiles(ctx context.Context, tags []string, metadata map[string]string) (*FileListResponse, error) {
+	query := url.Values{}
+	for _, tag := range tags {
+		query.Add("tag", tag)
+	}
+	for k, v := range metadata {
+		query.Set("meta."+k, v)
+	}
+
+	resp, err := c.Get(ctx, "/api/v1/files?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	var files FileListResponse
+	err = c.ParseResponse(resp, &files)
+	return &files, err
+}
+
 // DeleteFile deletes a file
 func (c *Client) DeleteFile(ctx context.Context, fileID string) error {
 	resp, err := c.Delete(ctx, "/api/v1/files/"+fileID)
@@ -379,6 +648,48 @@ func (c *Client) RemovePeer(ctx context.Context, peerID string) error {
 	return c.ParseResponse(resp, nil)
 }
 
+// TopologyNode is a node of the peer latency map
+type TopologyNode struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"`
+	Self bool   `json:"self"`
+}
+
+// TopologyLink is the latency measured from one node to another
+type TopologyLink struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	RTTMs     float64   `json:"rtt_ms"`
+	MinRTTMs  float64   `json:"min_rtt_ms"`
+	JitterMs  float64   `json:"jitter_ms"`
+	Loss      float64   `json:"loss"`
+	Samples   uint64    `json:"samples"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PeerTopology is the latency map of the network seen from the server.
+// Matrix[i][j] is the round trip time in milliseconds from Nodes[i] to
+// Nodes[j], or nil if it was not measured.
+type PeerTopology struct {
+	Self        string         `json:"self"`
+	Nodes       []TopologyNode `json:"nodes"`
+	Links       []TopologyLink `json:"links"`
+	Matrix      [][]*float64   `json:"matrix"`
+	GeneratedAt time.Time      `json:"generated_at"`
+}
+
+// GetPeerTopology gets the round trip times between the peers
+func (c *Client) GetPeerTopology(ctx context.Context) (*PeerTopology, error) {
+	resp, err := c.Get(ctx, "/api/v1/peers/topology")
+	if err != nil {
+		return nil, err
+	}
+
+	var topology PeerTopology
+	err = c.ParseResponse(resp, &topology)
+	return &topology, err
+}
+
 // System operations
 type HealthStatus struct {
 	Status    string            `json:"status"`
@@ -416,3 +727,1783 @@ func (c *Client) GetMetrics(ctx context.Context) (*Metrics, error) {
 	err = c.ParseResponse(resp, &metrics)
 	return &metrics, err
 }
+
+// Metadata replication operations
+type MetadataStatus struct {
+	Role         string    `json:"role"`
+	PrimaryURL   string    `json:"primary_url"`
+	Connected    bool      `json:"connected"`
+	AppliedIndex uint64    `json:"applied_index"`
+	PrimaryIndex uint64    `json:"primary_index"`
+	Lag          uint64    `json:"lag"`
+	Epoch        uint64    `json:"epoch"`
+	LastContact  time.Time `json:"last_contact"`
+}
+
+type MetadataPromoteResult struct {
+	Role  string `json:"role"`
+	Epoch uint64 `json:"epoch"`
+	Index uint64 `json:"index"`
+}
+
+// GetMetadataStatus gets the replication status of a metadata node
+func (c *Client) GetMetadataStatus(ctx context.Context) (*MetadataStatus, error) {
+	resp, err := c.Get(ctx, "/metadata/status")
+	if err != nil {
+		return nil, err
+	}
+
+	var status MetadataStatus
+	err = c.ParseResponse(resp, &status)
+	return &status, err
+}
+
+// PromoteMetadataStandby promotes a metadata standby to primary
+func (c *Client) PromoteMetadataStandby(ctx context.Context) (*MetadataPromoteResult, error) {
+	resp, err := c.Post(ctx, "/metadata/promote", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result MetadataPromoteResult
+	err = c.ParseResponse(resp, &result)
+	return &result, err
+}
+
+// Cluster metadata consensus operations
+type ClusterPeer struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	MatchIndex  uint64    `json:"match_index"`
+	LastContact time.Time `json:"last_contact"`
+}
+
+type ClusterStatus struct {
+	ID            string        `json:"id"`
+	Role          string        `json:"role"`
+	Term          uint64        `json:"term"`
+	Leader        string        `json:"leader"`
+	LeaderURL     string        `json:"leader_url"`
+	LastIndex     uint64        `json:"last_index"`
+	CommitIndex   uint64        `json:"commit_index"`
+	AppliedIndex  uint64        `json:"applied_index"`
+	SnapshotIndex uint64        `json:"snapshot_index"`
+	Peers         []ClusterPeer `json:"peers"`
+}
+
+type ClusterMember struct {
+	ID        string            `json:"id"`
+	Addr      string            `json:"addr"`
+	Capacity  int64             `json:"capacity"`
+	Labels    map[string]string `json:"labels"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+type ClusterNamespace struct {
+	Name      string            `json:"name"`
+	Config    map[string]string `json:"config"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+type ClusterLease struct {
+	Name      string    `json:"name"`
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Token     uint64    `json:"token"`
+}
+
+type ClusterState struct {
+	Index      uint64                     `json:"index"`
+	Members    []ClusterMember            `json:"members"`
+	Namespaces []ClusterNamespace         `json:"namespaces"`
+	Policies   map[string]json.RawMessage `json:"policies"`
+	Locks      []FileLock                 `json:"locks"`
+	Leases     []ClusterLease             `json:"leases"`
+}
+
+// GetClusterStatus gets the Raft status of a node of the metadata group
+func (c *Client) GetClusterStatus(ctx context.Context) (*ClusterStatus, error) {
+	resp, err := c.Get(ctx, "/cluster/status")
+	if err != nil {
+		return nil, err
+	}
+
+	var status ClusterStatus
+	err = c.ParseResponse(resp, &status)
+	return &status, err
+}
+
+// GetClusterState gets the cluster metadata as applied on a node of the
+// metadata group
+func (c *Client) GetClusterState(ctx context.Context) (*ClusterState, error) {
+	resp, err := c.Get(ctx, "/cluster/state")
+	if err != nil {
+		return nil, err
+	}
+
+	var state ClusterState
+	err = c.ParseResponse(resp, &state)
+	return &state, err
+}
+
+// Search operations
+type SearchHit struct {
+	Key         string   `json:"key"`
+	Name        string   `json:"name"`
+	ContentType string   `json:"content_type,omitempty"`
+	Score       float64  `json:"score"`
+	Highlights  []string `json:"highlights,omitempty"`
+}
+
+type SearchResults struct {
+	Query   string      `json:"query"`
+	Total   int         `json:"total"`
+	Limit   int         `json:"limit"`
+	Offset  int         `json:"offset"`
+	TookMs  float64     `json:"took_ms"`
+	Results []SearchHit `json:"results"`
+}
+
+type SearchIndexStatus struct {
+	Documents   int        `json:"documents"`
+	Terms       int        `json:"terms"`
+	Rebuilding  bool       `json:"rebuilding"`
+	Persistent  bool       `json:"persistent"`
+	LastRebuild *time.Time `json:"last_rebuild,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// SearchDocuments runs a full-text query over stored documents
+func (c *Client) SearchDocuments(ctx context.Context, query string, limit, offset int, highlight bool) (*SearchResults, error) {
+	params := url.Values{}
+	params.Set("q", query)
+	if limit > 0 {
+		params.Set("limit", fmt.Sprintf("%d", limit))
+	}
+	if offset > 0 {
+		params.Set("offset", fmt.Sprintf("%d", offset))
+	}
+	if !highlight {
+		params.Set("highlight", "false")
+	}
+
+	resp, err := c.Get(ctx, "/api/v1/search?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	var results SearchResults
+	err = c.ParseResponse(resp, &results)
+	return &results, err
+}
+
+// GetSearchStatus gets the state of the search index
+func (c *Client) GetSearchStatus(ctx context.Context) (*SearchIndexStatus, error) {
+	resp, err := c.Get(ctx, "/api/v1/search/status")
+	if err != nil {
+		return nil, err
+	}
+
+	var status SearchIndexStatus
+	err = c.ParseResponse(resp, &status)
+	return &status, err
+}
+
+// RebuildSearchIndex starts rebuilding the search index
+func (c *Client) RebuildSearchIndex(ctx context.Context) (*SearchIndexStatus, error) {
+	resp, err := c.Post(ctx, "/api/v1/search/rebuild", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var status SearchIndexStatus
+	err = c.ParseResponse(resp, &status)
+	return &status, err
+}
+
+// Share link operations
+type ShareLink struct {
+	ID                string     `json:"id"`
+	Key               string     `json:"key"`
+	URL               string     `json:"url"`
+	CreatedBy         string     `json:"created_by,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	ExpiresAt         time.Time  `json:"expires_at"`
+	MaxDownloads      int        `json:"max_downloads"`
+	Downloads         int        `json:"downloads"`
+	LastAccessed      *time.Time `json:"last_accessed,omitempty"`
+	PasswordProtected bool       `json:"password_protected"`
+	Revoked           bool       `json:"revoked"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	Active            bool       `json:"active"`
+}
+
+type ShareLinkList struct {
+	Links []ShareLink `json:"links"`
+	Total int         `json:"total"`
+}
+
+// ShareLinkOptions describes a new share link
+type ShareLinkOptions struct {
+	Key          string `json:"key"`
+	ExpiresIn    string `json:"expires_in,omitempty"`
+	MaxDownloads int    `json:"max_downloads,omitempty"`
+	Password     string `json:"password,omitempty"`
+}
+
+// CreateShareLink issues a signed download link for a file
+func (c *Client) CreateShareLink(ctx context.Context, opts *ShareLinkOptions) (*ShareLink, error) {
+	body, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Post(ctx, "/api/v1/shares", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var link ShareLink
+	err = c.ParseResponse(resp, &link)
+	return &link, err
+}
+
+// ListShareLinks lists the share links of a file, or all links when fileID is empty
+func (c *Client) ListShareLinks(ctx context.Context, fileID string) (*ShareLinkList, error) {
+	endpoint := "/api/v1/shares"
+	if fileID != "" {
+		endpoint += "?key=" + url.QueryEscape(fileID)
+	}
+
+	resp, err := c.Get(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var links ShareLinkList
+	err = c.ParseResponse(resp, &links)
+	return &links, err
+}
+
+// RevokeShareLink disables a share link
+func (c *Client) RevokeShareLink(ctx context.Context, linkID string) (*ShareLink, error) {
+	resp, err := c.Delete(ctx, "/api/v1/shares/"+url.PathEscape(linkID))
+	if err != nil {
+		return nil, err
+	}
+
+	var link ShareLink
+	err = c.ParseResponse(resp, &link)
+	return &link, err
+}
+
+// Retention lock operations
+type FileLock struct {
+	Key         string     `json:"key"`
+	Locked      bool       `json:"locked"`
+	LegalHold   bool       `json:"legal_hold"`
+	RetainUntil *time.Time `json:"retain_until,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+	UpdatedBy   string     `json:"updated_by,omitempty"`
+}
+
+type FileLockList struct {
+	Locks []FileLock `json:"locks"`
+	Total int        `json:"total"`
+}
+
+// FileLockUpdate changes a file lock; nil fields are left unchanged
+type FileLockUpdate struct {
+	RetainUntil string `json:"retain_until,omitempty"`
+	LegalHold   *bool  `json:"legal_hold,omitempty"`
+}
+
+// GetFileLock gets the retention lock of a file
+func (c *Client) GetFileLock(ctx context.Context, fileID string) (*FileLock, error) {
+	resp, err := c.Get(ctx, "/api/v1/files/"+url.PathEscape(fileID)+"/lock")
+	if err != nil {
+		return nil, err
+	}
+
+	var lock FileLock
+	err = c.ParseResponse(resp, &lock)
+	return &lock, err
+}
+
+// UpdateFileLock extends retention or places/releases a legal hold
+func (c *Client) UpdateFileLock(ctx context.Context, fileID string, update *FileLockUpdate) (*FileLock, error) {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Put(ctx, "/api/v1/files/"+url.PathEscape(fileID)+"/lock", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var lock FileLock
+	err = c.ParseResponse(resp, &lock)
+	return &lock, err
+}
+
+// ListFileLocks lists the locks in force
+func (c *Client) ListFileLocks(ctx context.Context) (*FileLockList, error) {
+	resp, err := c.Get(ctx, "/api/v1/locks")
+	if err != nil {
+		return nil, err
+	}
+
+	var locks FileLockList
+	err = c.ParseResponse(resp, &locks)
+	return &locks, err
+}
+
+// Policy operations
+type PolicyRule struct {
+	ID          string   `json:"id"`
+	Description string   `json:"description,omitempty"`
+	Disabled    bool     `json:"disabled,omitempty"`
+	Operations  []string `json:"operations,omitempty"`
+	Deny        string   `json:"deny"`
+	Message     string   `json:"message,omitempty"`
+}
+
+type PolicyInfo struct {
+	Rules  []PolicyRule `json:"rules"`
+	DryRun bool         `json:"dry_run"`
+}
+
+type PolicyDenial struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type PolicyDecision struct {
+	Time      time.Time      `json:"time"`
+	Operation string         `json:"op"`
+	Key       string         `json:"key"`
+	Tenant    string         `json:"tenant,omitempty"`
+	Peer      string         `json:"peer,omitempty"`
+	Allowed   bool           `json:"allowed"`
+	Denials   []PolicyDenial `json:"denials,omitempty"`
+	DryRun    bool           `json:"dry_run,omitempty"`
+}
+
+type PolicyDecisionList struct {
+	Decisions []PolicyDecision `json:"decisions"`
+	Total     int              `json:"total"`
+}
+
+// GetPolicy gets the rules the node evaluates on store, replicate and share
+func (c *Client) GetPolicy(ctx context.Context) (*PolicyInfo, error) {
+	resp, err := c.Get(ctx, "/api/v1/policy")
+	if err != nil {
+		return nil, err
+	}
+
+	var policy PolicyInfo
+	err = c.ParseResponse(resp, &policy)
+	return &policy, err
+}
+
+// ListPolicyDecisions lists the most recent policy decisions, newest first
+func (c *Client) ListPolicyDecisions(ctx context.Context, limit int, denied bool) (*PolicyDecisionList, error) {
+	resp, err := c.Get(ctx, fmt.Sprintf("/api/v1/policy/decisions?limit=%d&denied=%t", limit, denied))
+	if err != nil {
+		return nil, err
+	}
+
+	var decisions PolicyDecisionList
+	err = c.ParseResponse(resp, &decisions)
+	return &decisions, err
+}
+
+// Hook operations
+type HookInfo struct {
+	Name       string        `json:"name"`
+	Point      string        `json:"point"`
+	Calls      uint64        `json:"calls"`
+	Rejections uint64        `json:"rejections"`
+	Errors     uint64        `json:"errors"`
+	Timeouts   uint64        `json:"timeouts"`
+	Duration   time.Duration `json:"duration"`
+	LastError  string        `json:"last_error,omitempty"`
+}
+
+type HookList struct {
+	Hooks []HookInfo `json:"hooks"`
+	Total int        `json:"total"`
+}
+
+// ListHooks lists the hooks the node runs with their counters
+func (c *Client) ListHooks(ctx context.Context) (*HookList, error) {
+	resp, err := c.Get(ctx, "/api/v1/hooks")
+	if err != nil {
+		return nil, err
+	}
+
+	var hooks HookList
+	err = c.ParseResponse(resp, &hooks)
+	return &hooks, err
+}
+
+// Export operations
+type ExportInfo struct {
+	Name        string    `json:"name"`
+	Dataset     string    `json:"dataset"`
+	Format      string    `json:"format"`
+	Schedule    string    `json:"schedule,omitempty"`
+	Range       string    `json:"range,omitempty"`
+	Dimension   string    `json:"dimension,omitempty"`
+	Prefix      string    `json:"prefix"`
+	Destination string    `json:"destination"`
+	LastRun     time.Time `json:"last_run,omitempty"`
+	LastKey     string    `json:"last_key,omitempty"`
+	LastRows    int       `json:"last_rows,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+type ExportList struct {
+	Exports []ExportInfo `json:"exports"`
+	Total   int          `json:"total"`
+}
+
+type ExportRun struct {
+	Export      string    `json:"export"`
+	Dataset     string    `json:"dataset"`
+	Format      string    `json:"format"`
+	Destination string    `json:"destination"`
+	Key         string    `json:"key"`
+	Rows        int       `json:"rows"`
+	Size        int       `json:"size"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// ListExports lists the exports of the node with their last run
+func (c *Client) ListExports(ctx context.Context) (*ExportList, error) {
+	resp, err := c.Get(ctx, "/api/v1/exports")
+	if err != nil {
+		return nil, err
+	}
+
+	var exports ExportList
+	err = c.ParseResponse(resp, &exports)
+	return &exports, err
+}
+
+// RunExport runs an export now
+func (c *Client) RunExport(ctx context.Context, name string) (*ExportRun, error) {
+	resp, err := c.Post(ctx, "/api/v1/exports/"+url.PathEscape(name)+"/run", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var run ExportRun
+	err = c.ParseResponse(resp, &run)
+	return &run, err
+}
+
+// Query operations
+type QueryResult struct {
+	Columns   []string `json:"columns"`
+	Rows      [][]any  `json:"rows"`
+	Total     int      `json:"total"`
+	Truncated bool     `json:"truncated,omitempty"`
+}
+
+type QueryTable struct {
+	Name        string   `json:"name"`
+	Columns     []string `json:"columns"`
+	Description string   `json:"description"`
+}
+
+type QueryTableList struct {
+	Tables []QueryTable `json:"tables"`
+	Total  int          `json:"total"`
+}
+
+// Query runs a read-only SQL query over the metadata index, returning at
+// most maxRows rows (zero for the server default)
+func (c *Client) Query(ctx context.Context, sql string, maxRows int) (*QueryResult, error) {
+	body, err := json.Marshal(map[string]any{"sql": sql, "max_rows": maxRows})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Post(ctx, "/api/v1/query", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var result QueryResult
+	err = c.ParseResponse(resp, &result)
+	return &result, err
+}
+
+// ListQueryTables lists the tables SQL queries can select from
+func (c *Client) ListQueryTables(ctx context.Context) (*QueryTableList, error) {
+	resp, err := c.Get(ctx, "/api/v1/query/tables")
+	if err != nil {
+		return nil, err
+	}
+
+	var tables QueryTableList
+	err = c.ParseResponse(resp, &tables)
+	return &tables, err
+}
+
+// Lifecycle operations
+type LifecycleRule struct {
+	ID                        string `json:"id"`
+	Disabled                  bool   `json:"disabled,omitempty"`
+	Prefix                    string `json:"prefix,omitempty"`
+	Tenant                    string `json:"tenant,omitempty"`
+	ExpireAfterDays           int    `json:"expire_after_days,omitempty"`
+	TransitionAfterDays       int    `json:"transition_after_days,omitempty"`
+	TransitionTo              string `json:"transition_to,omitempty"`
+	NoncurrentExpireAfterDays int    `json:"noncurrent_expire_after_days,omitempty"`
+}
+
+type LifecyclePolicy struct {
+	Rules     []LifecycleRule `json:"rules"`
+	Enforcing bool            `json:"enforcing,omitempty"`
+}
+
+type LifecycleAction struct {
+	Type         string `json:"type"`
+	RuleID       string `json:"rule_id"`
+	Key          string `json:"key"`
+	Tenant       string `json:"tenant,omitempty"`
+	VersionID    string `json:"version_id,omitempty"`
+	StorageClass string `json:"storage_class,omitempty"`
+	Size         int64  `json:"size"`
+	AgeDays      int    `json:"age_days"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+}
+
+type LifecycleSummary struct {
+	Expired              int   `json:"expired"`
+	Transitioned         int   `json:"transitioned"`
+	VersionsDeleted      int   `json:"versions_deleted"`
+	Failed               int   `json:"failed"`
+	BytesExpired         int64 `json:"bytes_expired"`
+	BytesTransitioned    int64 `json:"bytes_transitioned"`
+	BytesVersionsDeleted int64 `json:"bytes_versions_deleted"`
+}
+
+type LifecycleReport struct {
+	DryRun     bool              `json:"dry_run"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Objects    int               `json:"objects"`
+	Rules      int               `json:"rules"`
+	Actions    []LifecycleAction `json:"actions"`
+	Summary    LifecycleSummary  `json:"summary"`
+}
+
+// GetLifecyclePolicy gets the active lifecycle policy
+func (c *Client) GetLifecyclePolicy(ctx context.Context) (*LifecyclePolicy, error) {
+	resp, err := c.Get(ctx, "/api/v1/lifecycle/policy")
+	if err != nil {
+		return nil, err
+	}
+
+	var policy LifecyclePolicy
+	err = c.ParseResponse(resp, &policy)
+	return &policy, err
+}
+
+// SetLifecyclePolicy replaces the lifecycle policy
+func (c *Client) SetLifecyclePolicy(ctx context.Context, rules []LifecycleRule) (*LifecyclePolicy, error) {
+	body, err := json.Marshal(LifecyclePolicy{Rules: rules})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Put(ctx, "/api/v1/lifecycle/policy", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var policy LifecyclePolicy
+	err = c.ParseResponse(resp, &policy)
+	return &policy, err
+}
+
+// RunLifecycle evaluates the lifecycle policy now. A dry run only reports
+// the actions that are due.
+func (c *Client) RunLifecycle(ctx context.Context, dryRun bool) (*LifecycleReport, error) {
+	resp, err := c.Post(ctx, fmt.Sprintf("/api/v1/lifecycle/run?dry_run=%t", dryRun), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var report LifecycleReport
+	err = c.ParseResponse(resp, &report)
+	return &report, err
+}
+
+// GetLifecycleReport gets the report of the most recent lifecycle run
+func (c *Client) GetLifecycleReport(ctx context.Context) (*LifecycleReport, error) {
+	resp, err := c.Get(ctx, "/api/v1/lifecycle/report")
+	if err != nil {
+		return nil, err
+	}
+
+	var report LifecycleReport
+	err = c.ParseResponse(resp, &report)
+	return &report, err
+}
+
+// Backup operations
+type BackupJob struct {
+	Name         string    `json:"name"`
+	Namespace    string    `json:"namespace"`
+	Target       string    `json:"target"`
+	Schedule     string    `json:"schedule,omitempty"`
+	FullSchedule string    `json:"full_schedule,omitempty"`
+	Keep         int       `json:"keep"`
+	Running      bool      `json:"running"`
+	LastRun      time.Time `json:"last_run,omitempty"`
+	LastSnapshot string    `json:"last_snapshot,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+	NextRun      time.Time `json:"next_run,omitempty"`
+}
+
+type BackupJobList struct {
+	Jobs  []BackupJob `json:"jobs"`
+	Total int         `json:"total"`
+}
+
+type BackupEntry struct {
+	Key      string `json:"key"`
+	Name     string `json:"name,omitempty"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum,omitempty"`
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+type BackupSnapshot struct {
+	ID          string        `json:"id"`
+	Job         string        `json:"job"`
+	Namespace   string        `json:"namespace"`
+	Type        string        `json:"type"`
+	Parent      string        `json:"parent,omitempty"`
+	Status      string        `json:"status"`
+	StartedAt   time.Time     `json:"started_at"`
+	CompletedAt time.Time     `json:"completed_at"`
+	Files       int           `json:"files"`
+	Size        int64         `json:"size"`
+	Changed     int           `json:"changed"`
+	Uploaded    int64         `json:"uploaded"`
+	Entries     []BackupEntry `json:"entries,omitempty"`
+}
+
+type BackupSnapshotList struct {
+	Snapshots []BackupSnapshot `json:"snapshots"`
+	Total     int              `json:"total"`
+}
+
+type BackupVerifyReport struct {
+	Snapshot   string    `json:"snapshot"`
+	Checked    int       `json:"checked"`
+	Bytes      int64     `json:"bytes"`
+	Missing    []string  `json:"missing,omitempty"`
+	Corrupt    []string  `json:"corrupt,omitempty"`
+	Healthy    bool      `json:"healthy"`
+	VerifiedAt time.Time `json:"verified_at"`
+}
+
+// BackupRestoreRequest selects a snapshot by ID or by point in time (RFC 3339)
+type BackupRestoreRequest struct {
+	Snapshot  string `json:"snapshot,omitempty"`
+	At        string `json:"at,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+}
+
+type BackupRestoreReport struct {
+	Snapshot string   `json:"snapshot"`
+	Restored int      `json:"restored"`
+	Skipped  int      `json:"skipped"`
+	Failed   int      `json:"failed"`
+	Bytes    int64    `json:"bytes"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// ListBackupJobs lists the configured backup jobs
+func (c *Client) ListBackupJobs(ctx context.Context) (*BackupJobList, error) {
+	resp, err := c.Get(ctx, "/api/v1/backups/jobs")
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs BackupJobList
+	err = c.ParseResponse(resp, &jobs)
+	return &jobs, err
+}
+
+// RunBackup starts a full or incremental backup of a job
+func (c *Client) RunBackup(ctx context.Context, job, backupType string) error {
+	resp, err := c.Post(ctx, "/api/v1/backups/jobs/"+url.PathEscape(job)+"/run?type="+url.QueryEscape(backupType), nil)
+	if err != nil {
+		return err
+	}
+	return c.ParseResponse(resp, nil)
+}
+
+// ListBackupSnapshots lists a job's snapshots, oldest first
+func (c *Client) ListBackupSnapshots(ctx context.Context, job string) (*BackupSnapshotList, error) {
+	resp, err := c.Get(ctx, "/api/v1/backups/jobs/"+url.PathEscape(job)+"/snapshots")
+	if err != nil {
+		return nil, err
+	}
+
+	var snaps BackupSnapshotList
+	err = c.ParseResponse(resp, &snaps)
+	return &snaps, err
+}
+
+// GetBackupSnapshot gets a snapshot including its entries
+func (c *Client) GetBackupSnapshot(ctx context.Context, job, id string) (*BackupSnapshot, error) {
+	resp, err := c.Get(ctx, "/api/v1/backups/jobs/"+url.PathEscape(job)+"/snapshots/"+url.PathEscape(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var snap BackupSnapshot
+	err = c.ParseResponse(resp, &snap)
+	return &snap, err
+}
+
+// VerifyBackupSnapshot checks a snapshot's blobs against their checksums
+func (c *Client) VerifyBackupSnapshot(ctx context.Context, job, id string) (*BackupVerifyReport, error) {
+	resp, err := c.Post(ctx, "/api/v1/backups/jobs/"+url.PathEscape(job)+"/snapshots/"+url.PathEscape(id)+"/verify", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var report BackupVerifyReport
+	err = c.ParseResponse(resp, &report)
+	return &report, err
+}
+
+// DeleteBackupSnapshot deletes a snapshot
+func (c *Client) DeleteBackupSnapshot(ctx context.Context, job, id string) error {
+	resp, err := c.Delete(ctx, "/api/v1/backups/jobs/"+url.PathEscape(job)+"/snapshots/"+url.PathEscape(id))
+	if err != nil {
+		return err
+	}
+	return c.ParseResponse(resp, nil)
+}
+
+// RestoreBackup restores files from a job's snapshot
+func (c *Client) RestoreBackup(ctx context.Context, job string, req *BackupRestoreRequest) (*BackupRestoreReport, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Post(ctx, "/api/v1/backups/jobs/"+url.PathEscape(job)+"/restore", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var report BackupRestoreReport
+	err = c.ParseResponse(resp, &report)
+	return &report, err
+}
+
+// Directory operations
+type DirectoryInfo struct {
+	Path     string `json:"path"`
+	Name     string `json:"name"`
+	Explicit bool   `json:"explicit"`
+	Files    int    `json:"files"`
+	Size     int64  `json:"size"`
+}
+
+type DirectoryObject struct {
+	Key         string    `json:"key"`
+	Name        string    `json:"name,omitempty"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+	ModTime     time.Time `json:"mod_time"`
+}
+
+type DirectoryListing struct {
+	Path        string            `json:"path"`
+	Recursive   bool              `json:"recursive"`
+	Directories []DirectoryInfo   `json:"directories"`
+	Files       []DirectoryObject `json:"files"`
+	TotalFiles  int               `json:"total_files"`
+	TotalSize   int64             `json:"total_size"`
+}
+
+type DirectoryReport struct {
+	From    string   `json:"from"`
+	To      string   `json:"to,omitempty"`
+	Files   int      `json:"files"`
+	Bytes   int64    `json:"bytes"`
+	Skipped int      `json:"skipped"`
+	Failed  int      `json:"failed"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// directoryPath escapes each segment of a folder path for the URL
+func directoryPath(path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return "/api/v1/directories/" + strings.Join(segments, "/")
+}
+
+// ListDirectory lists a folder, or everything below it when recursive; an
+// empty path lists the root
+func (c *Client) ListDirectory(ctx context.Context, path string, recursive bool) (*DirectoryListing, error) {
+	endpoint := directoryPath(path)
+	if recursive {
+		endpoint += "?recursive=true"
+	}
+	resp, err := c.Get(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var listing DirectoryListing
+	err = c.ParseResponse(resp, &listing)
+	return &listing, err
+}
+
+// MakeDirectory creates a folder that is kept while empty
+func (c *Client) MakeDirectory(ctx context.Context, path string) (*DirectoryInfo, error) {
+	resp, err := c.Put(ctx, directoryPath(path), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var dir DirectoryInfo
+	err = c.ParseResponse(resp, &dir)
+	return &dir, err
+}
+
+// DeleteDirectory deletes a file or folder; a folder holding files only
+// when recursive
+func (c *Client) DeleteDirectory(ctx context.Context, path string, recursive bool) (*DirectoryReport, error) {
+	endpoint := directoryPath(path)
+	if recursive {
+		endpoint += "?recursive=true"
+	}
+	resp, err := c.Delete(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var report DirectoryReport
+	err = c.ParseResponse(resp, &report)
+	return &report, err
+}
+
+// CopyPath copies a file or folder on the server
+func (c *Client) CopyPath(ctx context.Context, from, to string, overwrite bool) (*DirectoryReport, error) {
+	return c.transferPath(ctx, "copy", from, to, overwrite)
+}
+
+// MovePath moves or renames a file or folder on the server without
+// downloading it
+func (c *Client) MovePath(ctx context.Context, from, to string, overwrite bool) (*DirectoryReport, error) {
+	return c.transferPath(ctx, "move", from, to, overwrite)
+}
+
+func (c *Client) transferPath(ctx context.Context, op, from, to string, overwrite bool) (*DirectoryReport, error) {
+	body, err := json.Marshal(map[string]interface{}{"from": from, "to": to, "overwrite": overwrite})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Post(ctx, "/api/v1/directories/"+op, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var report DirectoryReport
+	err = c.ParseResponse(resp, &report)
+	return &report, err
+}
+
+// Snapshot operations
+type SnapshotEntry struct {
+	Key         string    `json:"key"`
+	Name        string    `json:"name,omitempty"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+	Hash        string    `json:"hash,omitempty"`
+	ModTime     time.Time `json:"mod_time"`
+}
+
+type NamespaceSnapshot struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name,omitempty"`
+	Namespace string          `json:"namespace"`
+	CreatedAt time.Time       `json:"created_at"`
+	Schedule  string          `json:"schedule,omitempty"`
+	Files     int             `json:"files"`
+	Size      int64           `json:"size"`
+	Entries   []SnapshotEntry `json:"entries,omitempty"`
+}
+
+type NamespaceSnapshotList struct {
+	Snapshots []NamespaceSnapshot `json:"snapshots"`
+	Total     int                 `json:"total"`
+}
+
+type SnapshotChange struct {
+	Key  string         `json:"key"`
+	Type string         `json:"type"`
+	From *SnapshotEntry `json:"from,omitempty"`
+	To   *SnapshotEntry `json:"to,omitempty"`
+}
+
+type SnapshotDiff struct {
+	From     string           `json:"from"`
+	To       string           `json:"to"`
+	Added    int              `json:"added"`
+	Removed  int              `json:"removed"`
+	Modified int              `json:"modified"`
+	Changes  []SnapshotChange `json:"changes"`
+}
+
+type SnapshotCloneReport struct {
+	Snapshot string   `json:"snapshot"`
+	Prefix   string   `json:"prefix"`
+	Cloned   int      `json:"cloned"`
+	Skipped  int      `json:"skipped"`
+	Failed   int      `json:"failed"`
+	Bytes    int64    `json:"bytes"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// ListSnapshots lists the snapshots of namespaces, oldest first
+func (c *Client) ListSnapshots(ctx context.Context) (*NamespaceSnapshotList, error) {
+	resp, err := c.Get(ctx, "/api/v1/snapshots")
+	if err != nil {
+		return nil, err
+	}
+
+	var snaps NamespaceSnapshotList
+	err = c.ParseResponse(resp, &snaps)
+	return &snaps, err
+}
+
+// CreateSnapshot snapshots the files whose keys start with namespace
+func (c *Client) CreateSnapshot(ctx context.Context, namespace, name string) (*NamespaceSnapshot, error) {
+	body, err := json.Marshal(map[string]string{"namespace": namespace, "name": name})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Post(ctx, "/api/v1/snapshots", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var snap NamespaceSnapshot
+	err = c.ParseResponse(resp, &snap)
+	return &snap, err
+}
+
+// GetSnapshot gets a snapshot including its entries
+func (c *Client) GetSnapshot(ctx context.Context, id string) (*NamespaceSnapshot, error) {
+	resp, err := c.Get(ctx, "/api/v1/snapshots/"+url.PathEscape(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var snap NamespaceSnapshot
+	err = c.ParseResponse(resp, &snap)
+	return &snap, err
+}
+
+// DeleteSnapshot deletes a snapshot
+func (c *Client) DeleteSnapshot(ctx context.Context, id string) error {
+	resp, err := c.Delete(ctx, "/api/v1/snapshots/"+url.PathEscape(id))
+	if err != nil {
+		return err
+	}
+	return c.ParseResponse(resp, nil)
+}
+
+// DownloadSnapshotContent writes a file as a snapshot recorded it to w
+func (c *Client) DownloadSnapshotContent(ctx context.Context, id, key string, w io.Writer) (int64, error) {
+	resp, err := c.Get(ctx, "/api/v1/snapshots/"+url.PathEscape(id)+"/files/"+url.PathEscape(key))
+	if err != nil {
+		return 0, fmt.Errorf("failed to download file: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("download failed %d: %s", resp.StatusCode, string(body))
+	}
+	return io.Copy(w, resp.Body)
+}
+
+// DiffSnapshot compares a snapshot with another one, or with the live
+// files when to is empty
+func (c *Client) DiffSnapshot(ctx context.Context, id, to string) (*SnapshotDiff, error) {
+	endpoint := "/api/v1/snapshots/" + url.PathEscape(id) + "/diff"
+	if to != "" {
+		endpoint += "?to=" + url.QueryEscape(to)
+	}
+	resp, err := c.Get(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var diff SnapshotDiff
+	err = c.ParseResponse(resp, &diff)
+	return &diff, err
+}
+
+// CloneSnapshot writes the files of a snapshot under prefix
+func (c *Client) CloneSnapshot(ctx context.Context, id, prefix string, overwrite bool) (*SnapshotCloneReport, error) {
+	body, err := json.Marshal(map[string]interface{}{"prefix": prefix, "overwrite": overwrite})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Post(ctx, "/api/v1/snapshots/"+url.PathEscape(id)+"/clone", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var report SnapshotCloneReport
+	err = c.ParseResponse(resp, &report)
+	return &report, err
+}
+
+// BackupSnapshot runs a backup job from a snapshot and waits for it
+func (c *Client) BackupSnapshot(ctx context.Context, id, job, backupType string) (*BackupSnapshot, error) {
+	body, err := json.Marshal(map[string]string{"job": job, "type": backupType})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Post(ctx, "/api/v1/snapshots/"+url.PathEscape(id)+"/backup", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var snap BackupSnapshot
+	err = c.ParseResponse(resp, &snap)
+	return &snap, err
+}
+
+// ReplicationTarget is the replication progress towards one remote cluster
+type ReplicationTarget struct {
+	Name          string    `json:"name"`
+	URL           string    `json:"url"`
+	Prefix        string    `json:"prefix"`
+	Connected     bool      `json:"connected"`
+	RemoteCluster string    `json:"remote_cluster"`
+	SourceIndex   uint64    `json:"source_index"`
+	AckedIndex    uint64    `json:"acked_index"`
+	LagEntries    uint64    `json:"lag_entries"`
+	LagSeconds    float64   `json:"lag_seconds"`
+	Sent          uint64    `json:"sent"`
+	Resyncs       uint64    `json:"resyncs"`
+	LastSync      time.Time `json:"last_sync"`
+	LastError     string    `json:"last_error"`
+}
+
+// ReplicationSource summarises the batches received from one remote cluster
+type ReplicationSource struct {
+	Cluster   string    `json:"cluster"`
+	Index     uint64    `json:"index"`
+	LastBatch time.Time `json:"last_batch"`
+	Applied   uint64    `json:"applied"`
+	Skipped   uint64    `json:"skipped"`
+	Conflicts uint64    `json:"conflicts"`
+	Failed    uint64    `json:"failed"`
+	LastError string    `json:"last_error"`
+}
+
+type ReplicationStatus struct {
+	ClusterID string              `json:"cluster_id"`
+	Policy    string              `json:"policy"`
+	Targets   []ReplicationTarget `json:"targets"`
+	Sources   []ReplicationSource `json:"sources"`
+}
+
+// GetReplicationStatus gets the geo-replication status of the cluster
+func (c *Client) GetReplicationStatus(ctx context.Context) (*ReplicationStatus, error) {
+	resp, err := c.Get(ctx, "/api/v1/replication/status")
+	if err != nil {
+		return nil, err
+	}
+
+	var status ReplicationStatus
+	err = c.ParseResponse(resp, &status)
+	return &status, err
+}
+
+// Access analytics
+
+// AccessCounters count the reads, writes and deletes a node served
+type AccessCounters struct {
+	Reads        int64 `json:"reads"`
+	Writes       int64 `json:"writes"`
+	Deletes      int64 `json:"deletes"`
+	BytesRead    int64 `json:"bytes_read"`
+	BytesWritten int64 `json:"bytes_written"`
+}
+
+// Total returns the number of accesses
+func (c AccessCounters) Total() int64 { return c.Reads + c.Writes + c.Deletes }
+
+// AccessRollup counts the accesses to one key, tenant or peer during a bucket
+type AccessRollup struct {
+	Start     time.Time `json:"start"`
+	Dimension string    `json:"dimension"`
+	Value     string    `json:"value"`
+	AccessCounters
+}
+
+type AccessRollups struct {
+	Dimension     string         `json:"dimension"`
+	BucketSeconds int64          `json:"bucket_seconds"`
+	Rollups       []AccessRollup `json:"rollups"`
+}
+
+// AccessTotal counts the accesses to one key, tenant or peer over a range
+type AccessTotal struct {
+	Value string `json:"value"`
+	AccessCounters
+}
+
+type AccessTop struct {
+	Dimension string        `json:"dimension"`
+	Totals    []AccessTotal `json:"totals"`
+}
+
+// accessParams encodes the parameters of an access analytics query; zero
+// times are left out
+func accessParams(dimension string, from, to time.Time) url.Values {
+	params := url.Values{}
+	params.Set("dimension", dimension)
+	if !from.IsZero() {
+		params.Set("from", from.UTC().Format(time.RFC3339))
+	}
+	if !to.IsZero() {
+		params.Set("to", to.UTC().Format(time.RFC3339))
+	}
+	return params
+}
+
+// GetAccessRollups gets the access rollups of the server by key, tenant or
+// peer; an empty value selects all of them
+func (c *Client) GetAccessRollups(ctx context.Context, dimension, value string, from, to time.Time) (*AccessRollups, error) {
+	params := accessParams(dimension, from, to)
+	if value != "" {
+		params.Set("value", value)
+	}
+	resp, err := c.Get(ctx, "/api/v1/analytics/access?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	var rollups AccessRollups
+	err = c.ParseResponse(resp, &rollups)
+	return &rollups, err
+}
+
+// GetTopAccessed gets the most accessed keys, tenants or peers of the server
+func (c *Client) GetTopAccessed(ctx context.Context, dimension string, from, to time.Time, limit int) (*AccessTop, error) {
+	params := accessParams(dimension, from, to)
+	params.Set("limit", fmt.Sprint(limit))
+	resp, err := c.Get(ctx, "/api/v1/analytics/access/top?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	var top AccessTop
+	err = c.ParseResponse(resp, &top)
+	return &top, err
+}
+
+// ScheduledReport is a report the server runs on the access rollups, with
+// the outcome of its last run
+type ScheduledReport struct {
+	Name      string    `json:"name,omitempty"`
+	Dimension string    `json:"dimension,omitempty"`
+	Value     string    `json:"value,omitempty"`
+	Metrics   []string  `json:"metrics,omitempty"`
+	Grouping  string    `json:"grouping,omitempty"`
+	Range     string    `json:"range,omitempty"`
+	Limit     int       `json:"limit,omitempty"`
+	Schedule  string    `json:"schedule,omitempty"`
+	Format    string    `json:"format,omitempty"`
+	Prefix    string    `json:"prefix,omitempty"`
+	Webhook   string    `json:"webhook,omitempty"`
+	Email     []string  `json:"email,omitempty"`
+	LastRun   time.Time `json:"last_run,omitempty"`
+	LastKey   string    `json:"last_key,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	NextRun   time.Time `json:"next_run,omitempty"`
+}
+
+type ScheduledReportList struct {
+	Reports []ScheduledReport `json:"reports"`
+	Total   int               `json:"total"`
+}
+
+// ReportRun is where a report run stored its output
+type ReportRun struct {
+	Report      string    `json:"report"`
+	Key         string    `json:"key"`
+	Format      string    `json:"format"`
+	Size        int       `json:"size"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Errors      []string  `json:"errors,omitempty"`
+}
+
+// ListScheduledReports lists the reports of the server
+func (c *Client) ListScheduledReports(ctx context.Context) (*ScheduledReportList, error) {
+	resp, err := c.Get(ctx, "/api/v1/analytics/reports")
+	if err != nil {
+		return nil, err
+	}
+
+	var reports ScheduledReportList
+	err = c.ParseResponse(resp, &reports)
+	return &reports, err
+}
+
+// PutScheduledReport creates or replaces a report on the server; its status
+// fields are ignored
+func (c *Client) PutScheduledReport(ctx context.Context, name string, report *ScheduledReport) (*ScheduledReport, error) {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Put(ctx, "/api/v1/analytics/reports/"+url.PathEscape(name), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var saved ScheduledReport
+	err = c.ParseResponse(resp, &saved)
+	return &saved, err
+}
+
+// RunScheduledReport runs a report on the server now
+func (c *Client) RunScheduledReport(ctx context.Context, name string) (*ReportRun, error) {
+	resp, err := c.Post(ctx, "/api/v1/analytics/reports/"+url.PathEscape(name)+"/run", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var run ReportRun
+	err = c.ParseResponse(resp, &run)
+	return &run, err
+}
+
+// DeleteScheduledReport deletes a report from the server
+func (c *Client) DeleteScheduledReport(ctx context.Context, name string) error {
+	resp, err := c.Delete(ctx, "/api/v1/analytics/reports/"+url.PathEscape(name))
+	if err != nil {
+		return err
+	}
+	return c.ParseResponse(resp, nil)
+}
+
+// AlertRule is a rule the server evaluates over its metrics
+type AlertRule struct {
+	Name      string   `json:"name,omitempty"`
+	Metric    string   `json:"metric"`
+	Kind      string   `json:"kind,omitempty"`
+	Op        string   `json:"op,omitempty"`
+	Threshold float64  `json:"threshold"`
+	Window    string   `json:"window,omitempty"`
+	For       string   `json:"for,omitempty"`
+	Severity  string   `json:"severity,omitempty"`
+	Summary   string   `json:"summary,omitempty"`
+	Channels  []string `json:"channels,omitempty"`
+}
+
+type AlertRuleList struct {
+	Rules []AlertRule `json:"rules"`
+	Total int         `json:"total"`
+}
+
+// AlertChannel is where the server sends alert notifications
+type AlertChannel struct {
+	Name string   `json:"name,omitempty"`
+	Type string   `json:"type"`
+	URL  string   `json:"url,omitempty"`
+	To   []string `json:"to,omitempty"`
+}
+
+type AlertChannelList struct {
+	Channels []AlertChannel `json:"channels"`
+	Total    int            `json:"total"`
+}
+
+// AlertSilence mutes the notifications of a rule, or of every rule
+type AlertSilence struct {
+	ID        string    `json:"id,omitempty"`
+	Rule      string    `json:"rule,omitempty"`
+	StartsAt  time.Time `json:"starts_at,omitzero"`
+	EndsAt    time.Time `json:"ends_at,omitzero"`
+	Duration  string    `json:"duration,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+}
+
+type AlertSilenceList struct {
+	Silences []AlertSilence `json:"silences"`
+	Total    int            `json:"total"`
+}
+
+// ServerAlert is the state of an alert rule on the server
+type ServerAlert struct {
+	Rule         string    `json:"rule"`
+	Severity     string    `json:"severity"`
+	Summary      string    `json:"summary,omitempty"`
+	State        string    `json:"state"`
+	Value        float64   `json:"value"`
+	HasValue     bool      `json:"has_value"`
+	Since        time.Time `json:"since,omitzero"`
+	FiredAt      time.Time `json:"fired_at,omitzero"`
+	ResolvedAt   time.Time `json:"resolved_at,omitzero"`
+	LastNotified time.Time `json:"last_notified,omitzero"`
+	Silenced     bool      `json:"silenced"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+type ServerAlertList struct {
+	Alerts []ServerAlert `json:"alerts"`
+	Total  int           `json:"total"`
+}
+
+// ListServerAlerts lists the state of every alert rule of the server
+func (c *Client) ListServerAlerts(ctx context.Context) (*ServerAlertList, error) {
+	resp, err := c.Get(ctx, "/api/v1/alerts")
+	if err != nil {
+		return nil, err
+	}
+
+	var alerts ServerAlertList
+	err = c.ParseResponse(resp, &alerts)
+	return &alerts, err
+}
+
+// ListAlertRules lists the alert rules of the server
+func (c *Client) ListAlertRules(ctx context.Context) (*AlertRuleList, error) {
+	resp, err := c.Get(ctx, "/api/v1/alerts/rules")
+	if err != nil {
+		return nil, err
+	}
+
+	var rules AlertRuleList
+	err = c.ParseResponse(resp, &rules)
+	return &rules, err
+}
+
+// PutAlertRule creates or replaces an alert rule on the server
+func (c *Client) PutAlertRule(ctx context.Context, name string, rule *AlertRule) (*AlertRule, error) {
+	body, err := json.Marshal(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Put(ctx, "/api/v1/alerts/rules/"+url.PathEscape(name), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var saved AlertRule
+	err = c.ParseResponse(resp, &saved)
+	return &saved, err
+}
+
+// DeleteAlertRule deletes an alert rule from the server
+func (c *Client) DeleteAlertRule(ctx context.Context, name string) error {
+	resp, err := c.Delete(ctx, "/api/v1/alerts/rules/"+url.PathEscape(name))
+	if err != nil {
+		return err
+	}
+	return c.ParseResponse(resp, nil)
+}
+
+// ListAlertChannels lists the notification channels of the server
+func (c *Client) ListAlertChannels(ctx context.Context) (*AlertChannelList, error) {
+	resp, err := c.Get(ctx, "/api/v1/alerts/channels")
+	if err != nil {
+		return nil, err
+	}
+
+	var channels AlertChannelList
+	err = c.ParseResponse(resp, &channels)
+	return &channels, err
+}
+
+// PutAlertChannel creates or replaces a notification channel on the server
+func (c *Client) PutAlertChannel(ctx context.Context, name string, channel *AlertChannel) (*AlertChannel, error) {
+	body, err := json.Marshal(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Put(ctx, "/api/v1/alerts/channels/"+url.PathEscape(name), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var saved AlertChannel
+	err = c.ParseResponse(resp, &saved)
+	return &saved, err
+}
+
+// DeleteAlertChannel deletes a notification channel from the server
+func (c *Client) DeleteAlertChannel(ctx context.Context, name string) error {
+	resp, err := c.Delete(ctx, "/api/v1/alerts/channels/"+url.PathEscape(name))
+	if err != nil {
+		return err
+	}
+	return c.ParseResponse(resp, nil)
+}
+
+// ListAlertSilences lists the silences of the server that have not ended
+func (c *Client) ListAlertSilences(ctx context.Context) (*AlertSilenceList, error) {
+	resp, err := c.Get(ctx, "/api/v1/alerts/silences")
+	if err != nil {
+		return nil, err
+	}
+
+	var silences AlertSilenceList
+	err = c.ParseResponse(resp, &silences)
+	return &silences, err
+}
+
+// AddAlertSilence silences notifications on the server
+func (c *Client) AddAlertSilence(ctx context.Context, silence *AlertSilence) (*AlertSilence, error) {
+	body, err := json.Marshal(silence)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Post(ctx, "/api/v1/alerts/silences", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var saved AlertSilence
+	err = c.ParseResponse(resp, &saved)
+	return &saved, err
+}
+
+// DeleteAlertSilence ends a silence on the server early
+func (c *Client) DeleteAlertSilence(ctx context.Context, id string) error {
+	resp, err := c.Delete(ctx, "/api/v1/alerts/silences/"+url.PathEscape(id))
+	if err != nil {
+		return err
+	}
+	return c.ParseResponse(resp, nil)
+}
+
+// DecommissionStatus is the progress of a node leaving the cluster
+type DecommissionStatus struct {
+	Phase      string    `json:"phase"`
+	Files      int       `json:"files"`
+	Evacuated  int       `json:"evacuated"`
+	Pending    int       `json:"pending"`
+	Copies     int       `json:"copies"`
+	Bytes      int64     `json:"bytes"`
+	LastError  string    `json:"last_error,omitempty"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// GetDecommission gets the decommission progress of the server
+func (c *Client) GetDecommission(ctx context.Context) (*DecommissionStatus, error) {
+	resp, err := c.Get(ctx, "/api/v1/peers/decommission")
+	if err != nil {
+		return nil, err
+	}
+
+	var status DecommissionStatus
+	err = c.ParseResponse(resp, &status)
+	return &status, err
+}
+
+// StartDecommission starts moving the files of the server to the rest of
+// the cluster so it can leave it
+func (c *Client) StartDecommission(ctx context.Context) (*DecommissionStatus, error) {
+	resp, err := c.Post(ctx, "/api/v1/peers/decommission", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var status DecommissionStatus
+	err = c.ParseResponse(resp, &status)
+	return &status, err
+}
+
+// AbortDecommission stops the decommission of the server and puts it back
+// on the ring
+func (c *Client) AbortDecommission(ctx context.Context) (*DecommissionStatus, error) {
+	resp, err := c.Delete(ctx, "/api/v1/peers/decommission")
+	if err != nil {
+		return nil, err
+	}
+
+	var status DecommissionStatus
+	err = c.ParseResponse(resp, &status)
+	return &status, err
+}
+
+// StorageViolation is an invariant of the server's store found broken
+type StorageViolation struct {
+	Invariant string `json:"invariant"`
+	Problem   string `json:"problem"`
+	Path      string `json:"path"`
+	Location  string `json:"location"`
+	Detail    string `json:"detail"`
+}
+
+// StorageCheck is the result of checking the server's store
+type StorageCheck struct {
+	Format     string             `json:"format"`
+	Objects    int                `json:"objects"`
+	Chunks     int                `json:"chunks"`
+	Violations []StorageViolation `json:"violations"`
+}
+
+// CheckStorage verifies the invariants of every object in the server's
+// store
+func (c *Client) CheckStorage(ctx context.Context) (*StorageCheck, error) {
+	resp, err := c.Get(ctx, "/api/v1/storage/fsck")
+	if err != nil {
+		return nil, err
+	}
+
+	var check StorageCheck
+	err = c.ParseResponse(resp, &check)
+	return &check, err
+}
+
+// ScheduledJob is periodic work of the server, such as reports, key
+// rotation, lifecycle runs and backups
+type ScheduledJob struct {
+	Name            string    `json:"name"`
+	Description     string    `json:"description,omitempty"`
+	Schedule        string    `json:"schedule,omitempty"`
+	DefaultSchedule string    `json:"default_schedule,omitempty"`
+	Jitter          string    `json:"jitter,omitempty"`
+	Paused          bool      `json:"paused"`
+	Running         bool      `json:"running"`
+	NextRun         time.Time `json:"next_run,omitempty"`
+	LastRun         *JobRun   `json:"last_run,omitempty"`
+	// Runs are the recent runs, newest first, when the job is fetched on
+	// its own
+	Runs []JobRun `json:"runs,omitempty"`
+}
+
+// JobRun is one run of a scheduled job
+type JobRun struct {
+	ID         string    `json:"id"`
+	Job        string    `json:"job"`
+	Trigger    string    `json:"trigger"`
+	Status     string    `json:"status"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// JobList is the scheduled jobs of the server
+type JobList struct {
+	Jobs  []ScheduledJob `json:"jobs"`
+	Total int            `json:"total"`
+}
+
+// JobUpdate changes a scheduled job; nil fields are left as they are
+type JobUpdate struct {
+	Schedule *string `json:"schedule,omitempty"`
+	Paused   *bool   `json:"paused,omitempty"`
+}
+
+// ListJobs lists the scheduled jobs of the server
+func (c *Client) ListJobs(ctx context.Context) (*JobList, error) {
+	resp, err := c.Get(ctx, "/api/v1/jobs")
+	if err != nil {
+		return nil, err
+	}
+
+	var list JobList
+	err = c.ParseResponse(resp, &list)
+	return &list, err
+}
+
+// GetJob gets a scheduled job with its recent runs
+func (c *Client) GetJob(ctx context.Context, name string) (*ScheduledJob, error) {
+	resp, err := c.Get(ctx, "/api/v1/jobs/"+url.PathEscape(name))
+	if err != nil {
+		return nil, err
+	}
+
+	var job ScheduledJob
+	err = c.ParseResponse(resp, &job)
+	return &job, err
+}
+
+// UpdateJob changes the schedule of a job or pauses it
+func (c *Client) UpdateJob(ctx context.Context, name string, update *JobUpdate) (*ScheduledJob, error) {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.makeRequest(ctx, "PATCH", "/api/v1/jobs/"+url.PathEscape(name), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var job ScheduledJob
+	err = c.ParseResponse(resp, &job)
+	return &job, err
+}
+
+// RunJob starts a run of a scheduled job now
+func (c *Client) RunJob(ctx context.Context, name string) (*JobRun, error) {
+	resp, err := c.Post(ctx, "/api/v1/jobs/"+url.PathEscape(name)+"/run", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var run JobRun
+	err = c.ParseResponse(resp, &run)
+	return &run, err
+}
+
+// QueuedJob is background work of the server retried until it succeeds,
+// such as a replica a peer missed or a webhook
+type QueuedJob struct {
+	ID          string          `json:"id"`
+	Kind        string          `json:"kind"`
+	Key         string          `json:"key,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Priority    int             `json:"priority"`
+	State       string          `json:"state"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	CreatedAt   time.Time       `json:"created_at"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	DeadAt      time.Time       `json:"dead_at,omitempty"`
+}
+
+// QueuedJobList is a list of queued jobs
+type QueuedJobList struct {
+	Jobs  []QueuedJob `json:"jobs"`
+	Total int         `json:"total"`
+}
+
+// QueueStats counts the queued jobs
+type QueueStats struct {
+	Pending   int            `json:"pending"`
+	Running   int            `json:"running"`
+	Dead      int            `json:"dead"`
+	Completed uint64         `json:"completed"`
+	Retried   uint64         `json:"retried"`
+	Kinds     map[string]int `json:"kinds,omitempty"`
+}
+
+// GetQueueStats counts the queued jobs of the server
+func (c *Client) GetQueueStats(ctx context.Context) (*QueueStats, error) {
+	resp, err := c.Get(ctx, "/api/v1/queue")
+	if err != nil {
+		return nil, err
+	}
+
+	var stats QueueStats
+	err = c.ParseResponse(resp, &stats)
+	return &stats, err
+}
+
+// ListQueuedJobs lists the queued jobs in a state and of a kind, all of
+// them when empty
+func (c *Client) ListQueuedJobs(ctx context.Context, state, kind string) (*QueuedJobList, error) {
+	params := url.Values{}
+	if state != "" {
+		params.Set("state", state)
+	}
+	if kind != "" {
+		params.Set("kind", kind)
+	}
+
+	resp, err := c.Get(ctx, "/api/v1/queue/jobs?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	var list QueuedJobList
+	err = c.ParseResponse(resp, &list)
+	return &list, err
+}
+
+// GetQueuedJob gets a queued job
+func (c *Client) GetQueuedJob(ctx context.Context, id string) (*QueuedJob, error) {
+	resp, err := c.Get(ctx, "/api/v1/queue/jobs/"+url.PathEscape(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var job QueuedJob
+	err = c.ParseResponse(resp, &job)
+	return &job, err
+}
+
+// RequeueJob runs a dead job again
+func (c *Client) RequeueJob(ctx context.Context, id string) (*QueuedJob, error) {
+	resp, err := c.Post(ctx, "/api/v1/queue/jobs/"+url.PathEscape(id)+"/requeue", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var job QueuedJob
+	err = c.ParseResponse(resp, &job)
+	return &job, err
+}
+
+// RequeueDeadJobs runs the dead jobs of a kind, all of them when empty,
+// again and returns how many it requeued
+func (c *Client) RequeueDeadJobs(ctx context.Context, kind string) (int, error) {
+	endpoint := "/api/v1/queue/dead/requeue"
+	if kind != "" {
+		endpoint += "?kind=" + url.QueryEscape(kind)
+	}
+	resp, err := c.Post(ctx, endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var result struct {
+		Requeued int `json:"requeued"`
+	}
+	err = c.ParseResponse(resp, &result)
+	return result.Requeued, err
+}
+
+// DeleteQueuedJob drops a pending or dead job
+func (c *Client) DeleteQueuedJob(ctx context.Context, id string) error {
+	resp, err := c.Delete(ctx, "/api/v1/queue/jobs/"+url.PathEscape(id))
+	if err != nil {
+		return err
+	}
+	return c.ParseResponse(resp, nil)
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Content types of JSON Patch and JSON Merge Patch bodies
+const (
+	JSONPatch      = "application/json-patch+json"
+	JSONMergePatch = "application/merge-patch+json"
+)
+
+// JSONDocument is a JSON document and its ETag
+type JSONDocument struct {
+	Data []byte
+	// ETag is the hash of the document, without quotes
+	ETag string
+}
+
+// JSONWrite sets the preconditions of a write to a JSON document
+type JSONWrite struct {
+	// IfMatch writes only if the document has this ETag
+	IfMatch string
+	// Create writes only if no document exists
+	Create bool
+}
+
+// GetJSON fetches a JSON document
+func (c *Client) GetJSON(ctx context.Context, key string) (*JSONDocument, error) {
+	return c.jsonRequest(ctx, "GET", key, "", nil, JSONWrite{})
+}
+
+// PutJSON creates or replaces a JSON document
+func (c *Client) PutJSON(ctx context.Context, key string, data []byte, w JSONWrite) (*JSONDocument, error) {
+	return c.jsonRequest(ctx, "PUT", key, "application/json", data, w)
+}
+
+// PatchJSON applies a patch of type JSONPatch or JSONMergePatch to a JSON
+// document
+func (c *Client) PatchJSON(ctx context.Context, key, patchType string, patch []byte, w JSONWrite) (*JSONDocument, error) {
+	return c.jsonRequest(ctx, "PATCH", key, patchType, patch, w)
+}
+
+// DeleteJSON deletes a JSON document
+func (c *Client) DeleteJSON(ctx context.Context, key string, w JSONWrite) error {
+	_, err := c.jsonRequest(ctx, "DELETE", key, "", nil, w)
+	return err
+}
+
+func (c *Client) jsonRequest(ctx context.Context, method, key, contentType string, body []byte, w JSONWrite) (*JSONDocument, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+"/api/v1/json/"+url.PathEscape(key), r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if w.IfMatch != "" {
+		req.Header.Set("If-Match", `"`+strings.Trim(w.IfMatch, `"`)+`"`)
+	}
+	if w.Create {
+		req.Header.Set("If-None-Match", "*")
+	}
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: string(data)}
+	}
+	return &JSONDocument{Data: data, ETag: strings.Trim(resp.Header.Get("ETag"), `"`)}, nil
+}
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// LogInfo describes an append-only log
+type LogInfo struct {
+	Name        string    `json:"name"`
+	StartOffset uint64    `json:"start_offset"`
+	NextOffset  uint64    `json:"next_offset"`
+	Records     uint64    `json:"records"`
+	Bytes       int64     `json:"bytes"`
+	Segments    int       `json:"segments"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// LogRecord is one record of a log
+type LogRecord struct {
+	Offset uint64    `json:"offset"`
+	Time   time.Time `json:"time"`
+	Data   []byte    `json:"data,omitempty"`
+}
+
+// LogRecords are records read from a log, and the offset to read from next
+type LogRecords struct {
+	Records    []LogRecord `json:"records"`
+	NextOffset uint64      `json:"next_offset"`
+}
+
+// LogQuery selects the records to read
+type LogQuery struct {
+	Offset uint64
+	Since  time.Time
+	Limit  int
+}
+
+func (q LogQuery) values() url.Values {
+	v := url.Values{}
+	v.Set("offset", strconv.FormatUint(q.Offset, 10))
+	if !q.Since.IsZero() {
+		v.Set("since", q.Since.Format(time.RFC3339))
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return v
+}
+
+func logPath(name string) string {
+	return "/api/v1/logs/" + url.PathEscape(name)
+}
+
+// ListLogs lists the append-only logs
+func (c *Client) ListLogs(ctx context.Context) ([]LogInfo, error) {
+	resp, err := c.Get(ctx, "/api/v1/logs")
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Logs []LogInfo `json:"logs"`
+	}
+	err = c.ParseResponse(resp, &list)
+	return list.Logs, err
+}
+
+// GetLog describes a log
+func (c *Client) GetLog(ctx context.Context, name string) (*LogInfo, error) {
+	resp, err := c.Get(ctx, logPath(name))
+	if err != nil {
+		return nil, err
+	}
+	var info LogInfo
+	err = c.ParseResponse(resp, &info)
+	return &info, err
+}
+
+// AppendLog appends records to a log, creating it if needed, and returns
+// their offsets. Several records are sent as NDJSON, so they must not
+// contain newlines.
+func (c *Client) AppendLog(ctx context.Context, name string, records ...[]byte) (*LogRecords, error) {
+	contentType := "application/octet-stream"
+	body := records[0]
+	if len(records) > 1 {
+		contentType = "application/x-ndjson"
+		body = bytes.Join(records, []byte("\n"))
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+logPath(name)+"/records", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	var result LogRecords
+	err = c.ParseResponse(resp, &result)
+	return &result, err
+}
+
+// ReadLog reads records of a log
+func (c *Client) ReadLog(ctx context.Context, name string, q LogQuery) (*LogRecords, error) {
+	resp, err := c.Get(ctx, logPath(name)+"/records?"+q.values().Encode())
+	if err != nil {
+		return nil, err
+	}
+	var result LogRecords
+	err = c.ParseResponse(resp, &result)
+	return &result, err
+}
+
+// FollowLog calls fn with the records of a log from q.Offset on, and with
+// new ones as they are appended, until ctx is done or fn fails
+func (c *Client) FollowLog(ctx context.Context, name string, q LogQuery, fn func(LogRecord) error) error {
+	v := q.values()
+	v.Set("follow", "true")
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+logPath(name)+"/records?"+v.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
+
+	// The stream lasts longer than the timeout of other requests
+	streaming := *c.httpClient
+	streaming.Timeout = 0
+	resp, err := streaming.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return &APIError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var rec LogRecord
+		if err := dec.Decode(&rec); err != nil {
+			if ctx.Err() != nil || err == io.EOF {
+				return ctx.Err()
+			}
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// TruncateLog drops the records of a log before offset and those older
+// than before
+func (c *Client) TruncateLog(ctx context.Context, name string, offset uint64, before time.Time) (*LogInfo, error) {
+	v := url.Values{}
+	if offset > 0 {
+		v.Set("before_offset", strconv.FormatUint(offset, 10))
+	}
+	if !before.IsZero() {
+		v.Set("before_time", before.Format(time.RFC3339))
+	}
+	resp, err := c.Delete(ctx, logPath(name)+"/records?"+v.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var info LogInfo
+	err = c.ParseResponse(resp, &info)
+	return &info, err
+}
+
+// DeleteLog deletes a log and its records
+func (c *Client) DeleteLog(ctx context.Context, name string) error {
+	resp, err := c.Delete(ctx, logPath(name))
+	if err != nil {
+		return err
+	}
+	return c.ParseResponse(resp, nil)
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/Skpow1234/Peervault/internal/cli/config"
+)
+
+// NewTLSConfig builds the TLS settings of a connection: an extra CA to
+// trust, a client certificate for mutual TLS and an expected server name
+func NewTLSConfig(cfg *config.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		if cfg.ClientCert == "" || cfg.ClientKey == "" {
+			return nil, fmt.Errorf("a client certificate needs both a certificate and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// SetTLS applies TLS settings to requests; nil restores the defaults
+func (c *Client) SetTLS(cfg *config.TLSConfig) error {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg != nil {
+		tlsConfig, err := NewTLSConfig(cfg)
+		if err != nil {
+			return err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	c.httpClient.Transport = transport
+	return nil
+}
+
+// UseConnection points the client at another server, as when switching
+// profiles. Later calls to ResetServerURL return to this server.
+func (c *Client) UseConnection(serverURL, token string, tlsCfg *config.TLSConfig) error {
+	if err := c.SetTLS(tlsCfg); err != nil {
+		return err
+	}
+	c.baseURL = serverURL
+	c.defaultURL = serverURL
+	c.authToken = token
+	return nil
+}
+
+// Transport returns the transport requests are sent through, with the TLS
+// settings of the connection
+func (c *Client) Transport() http.RoundTripper {
+	if c.httpClient.Transport == nil {
+		return http.DefaultTransport
+	}
+	return c.httpClient.Transport
+}
+
+// AuthToken returns the token requests are authenticated with
+func (c *Client) AuthToken() string {
+	return c.authToken
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Skpow1234/Peervault/pkg/merkle"
+)
+
+// ErrRangeIgnored is returned by DownloadRange when the server sent the
+// whole file instead of the requested range, because it does not support
+// ranges or because the file changed
+var ErrRangeIgnored = errors.New("server ignored the range request")
+
+// Upload is a resumable upload and the parts the server has received
+type Upload struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	PartSize  int64     `json:"part_size"`
+	PartCount int       `json:"part_count"`
+	Parts     []int     `json:"parts"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UploadOptions describes a file to upload in parts
+type UploadOptions struct {
+	Name        string            `json:"name"`
+	ContentType string            `json:"content_type,omitempty"`
+	Size        int64             `json:"size"`
+	PartSize    int64             `json:"part_size,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// CreateUpload starts a resumable upload
+func (c *Client) CreateUpload(ctx context.Context, opts *UploadOptions) (*Upload, error) {
+	body, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Post(ctx, "/api/v1/uploads", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var upload Upload
+	err = c.ParseResponse(resp, &upload)
+	return &upload, err
+}
+
+// GetUpload returns an upload with the parts received so far
+func (c *Client) GetUpload(ctx context.Context, uploadID string) (*Upload, error) {
+	resp, err := c.Get(ctx, "/api/v1/uploads/"+url.PathEscape(uploadID))
+	if err != nil {
+		return nil, err
+	}
+
+	var upload Upload
+	err = c.ParseResponse(resp, &upload)
+	return &upload, err
+}
+
+// UploadPart sends part n of an upload, size bytes read from r. A
+// non-empty checksum is the hex SHA-256 the server verifies the part
+// against. It makes a single attempt, since r cannot be rewound.
+func (c *Client) UploadPart(ctx context.Context, uploadID string, part int, r io.Reader, size int64, checksum string) error {
+	endpoint := fmt.Sprintf("%s/api/v1/uploads/%s/parts/%d", c.baseURL, url.PathEscape(uploadID), part)
+	req, err := http.NewRequestWithContext(ctx, "PUT", endpoint, r)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if checksum != "" {
+		req.Header.Set("X-Content-SHA256", checksum)
+	}
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload part %d: %w", part, err)
+	}
+	return c.ParseResponse(resp, nil)
+}
+
+// CompleteUpload stores the file once every part has been sent
+func (c *Client) CompleteUpload(ctx context.Context, uploadID string) (*FileInfo, error) {
+	resp, err := c.Post(ctx, "/api/v1/uploads/"+url.PathEscape(uploadID)+"/complete", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var file FileInfo
+	err = c.ParseResponse(resp, &file)
+	return &file, err
+}
+
+// AbortUpload discards an upload and the parts sent so far
+func (c *Client) AbortUpload(ctx context.Context, uploadID string) error {
+	resp, err := c.Delete(ctx, "/api/v1/uploads/"+url.PathEscape(uploadID))
+	if err != nil {
+		return err
+	}
+
+	return c.ParseResponse(resp, nil)
+}
+
+// GetFileManifest returns the Merkle tree of a file cut into chunks of
+// chunkSize bytes, the server default when it is zero
+func (c *Client) GetFileManifest(ctx context.Context, key string, chunkSize int64) (*merkle.Manifest, error) {
+	endpoint := "/api/v1/files/" + url.PathEscape(key) + "/manifest"
+	if chunkSize > 0 {
+		endpoint += "?chunk_size=" + strconv.FormatInt(chunkSize, 10)
+	}
+	resp, err := c.Get(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest merkle.Manifest
+	if err := c.ParseResponse(resp, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// DownloadRange streams length bytes of a file from offset to w. A
+// non-empty hash makes the server refuse the range if the content no
+// longer has that hash, so parts of different versions are never mixed.
+func (c *Client) DownloadRange(ctx context.Context, key, hash string, offset, length int64, w io.Writer) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/api/v1/files/"+url.PathEscape(key)+"/content", nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-"+strconv.FormatInt(offset+length-1, 10))
+	if hash != "" {
+		req.Header.Set("If-Range", `"`+hash+`"`)
+	}
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download file: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+	case resp.StatusCode == http.StatusOK:
+		return 0, ErrRangeIgnored
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("download failed %d: %s", resp.StatusCode, string(body))
+	}
+	return io.Copy(w, io.LimitReader(resp.Body, length))
+}
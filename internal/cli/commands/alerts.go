@@ -0,0 +1,285 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+)
+
+// handleAlerts runs the monitor alerts subcommands, which manage the
+// alerting engine of the server. Without a subcommand it shows the server's
+// alerts, or the local ones when the server evaluates no alert rules.
+func (c *MonitorCommand) handleAlerts(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return c.showServerAlerts(ctx)
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "rule":
+		return c.putAlertRule(ctx, args[1:])
+	case "rules":
+		return c.listAlertRules(ctx)
+	case "delete-rule":
+		return c.deleteAlertRule(ctx, args[1:])
+	case "channel":
+		return c.putAlertChannel(ctx, args[1:])
+	case "channels":
+		return c.listAlertChannels(ctx)
+	case "delete-channel":
+		return c.deleteAlertChannel(ctx, args[1:])
+	case "silence":
+		return c.addAlertSilence(ctx, args[1:])
+	case "silences":
+		return c.listAlertSilences(ctx)
+	case "unsilence":
+		return c.deleteAlertSilence(ctx, args[1:])
+	default:
+		return fmt.Errorf("unknown alerts subcommand: %s", args[0])
+	}
+}
+
+func (c *MonitorCommand) showServerAlerts(ctx context.Context) error {
+	alerts, err := c.client.ListServerAlerts(ctx)
+	if client.IsNotFound(err) {
+		return c.showAlerts()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list alerts: %w", err)
+	}
+
+	return c.formatter.PrintResult(alerts, func() {
+		if len(alerts.Alerts) == 0 {
+			c.formatter.PrintInfo("No alert rules on the server")
+			return
+		}
+		rows := make([][]string, len(alerts.Alerts))
+		for i, a := range alerts.Alerts {
+			state := a.State
+			if a.Silenced {
+				state += " (silenced)"
+			}
+			value := "-"
+			if a.HasValue {
+				value = strconv.FormatFloat(a.Value, 'g', 6, 64)
+			}
+			rows[i] = []string{a.Rule, a.Severity, state, value, formatTime(a.Since), orDash(a.LastError)}
+		}
+		c.formatter.PrintTable([]string{"Rule", "Severity", "State", "Value", "Since", "Last Error"}, rows)
+	})
+}
+
+// putAlertRule takes the rule's fields as key=value arguments, e.g.
+// monitor alerts rule no-peers metric=peers op="<" threshold=1 for=5m channels=ops
+func (c *MonitorCommand) putAlertRule(ctx context.Context, args []string) error {
+	usage := "usage: monitor alerts rule <name> metric=<metric> [kind=threshold|absence|rate] [op=<op>] [threshold=<n>] [window=<duration>] [for=<duration>] [severity=info|warning|critical] [summary=<text>] [channels=<a,b>]"
+	if len(args) < 2 {
+		return fmt.Errorf("%s", usage)
+	}
+
+	rule := &client.AlertRule{}
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("%s", usage)
+		}
+		switch key {
+		case "metric":
+			rule.Metric = value
+		case "kind":
+			rule.Kind = value
+		case "op":
+			rule.Op = value
+		case "threshold":
+			threshold, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("invalid threshold: %s", value)
+			}
+			rule.Threshold = threshold
+		case "window":
+			rule.Window = value
+		case "for":
+			rule.For = value
+		case "severity":
+			rule.Severity = value
+		case "summary":
+			rule.Summary = value
+		case "channels":
+			rule.Channels = strings.Split(value, ",")
+		default:
+			return fmt.Errorf("unknown rule field: %s", key)
+		}
+	}
+
+	saved, err := c.client.PutAlertRule(ctx, args[0], rule)
+	if err != nil {
+		return fmt.Errorf("failed to save alert rule: %w", err)
+	}
+
+	c.formatter.PrintSuccess(fmt.Sprintf("Alert rule saved: %s (%s)", saved.Name, describeAlertRule(saved)))
+	return nil
+}
+
+func describeAlertRule(r *client.AlertRule) string {
+	var desc string
+	switch r.Kind {
+	case "absence":
+		desc = fmt.Sprintf("%s not reported for %s", r.Metric, r.Window)
+	case "rate":
+		desc = fmt.Sprintf("rate of %s over %s %s %g/s", r.Metric, r.Window, r.Op, r.Threshold)
+	default:
+		desc = fmt.Sprintf("%s %s %g", r.Metric, r.Op, r.Threshold)
+	}
+	if r.For != "" {
+		desc += " for " + r.For
+	}
+	return desc
+}
+
+func (c *MonitorCommand) listAlertRules(ctx context.Context) error {
+	rules, err := c.client.ListAlertRules(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list alert rules: %w", err)
+	}
+
+	return c.formatter.PrintResult(rules, func() {
+		if len(rules.Rules) == 0 {
+			c.formatter.PrintInfo("No alert rules on the server")
+			return
+		}
+		rows := make([][]string, len(rules.Rules))
+		for i := range rules.Rules {
+			r := &rules.Rules[i]
+			rows[i] = []string{r.Name, describeAlertRule(r), r.Severity, orDash(strings.Join(r.Channels, ", "))}
+		}
+		c.formatter.PrintTable([]string{"Rule", "Condition", "Severity", "Channels"}, rows)
+	})
+}
+
+func (c *MonitorCommand) deleteAlertRule(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: monitor alerts delete-rule <name>")
+	}
+
+	if err := c.client.DeleteAlertRule(ctx, args[0]); err != nil {
+		return fmt.Errorf("failed to delete alert rule: %w", err)
+	}
+
+	c.formatter.PrintSuccess(fmt.Sprintf("Alert rule deleted: %s", args[0]))
+	return nil
+}
+
+func (c *MonitorCommand) putAlertChannel(ctx context.Context, args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("usage: monitor alerts channel <name> <webhook|slack|email> <url|address>...")
+	}
+
+	channel := &client.AlertChannel{Type: strings.ToLower(args[1])}
+	if channel.Type == "email" {
+		channel.To = args[2:]
+	} else {
+		channel.URL = args[2]
+	}
+
+	saved, err := c.client.PutAlertChannel(ctx, args[0], channel)
+	if err != nil {
+		return fmt.Errorf("failed to save alert channel: %w", err)
+	}
+
+	c.formatter.PrintSuccess(fmt.Sprintf("Alert channel saved: %s (%s)", saved.Name, saved.Type))
+	return nil
+}
+
+func (c *MonitorCommand) listAlertChannels(ctx context.Context) error {
+	channels, err := c.client.ListAlertChannels(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list alert channels: %w", err)
+	}
+
+	return c.formatter.PrintResult(channels, func() {
+		if len(channels.Channels) == 0 {
+			c.formatter.PrintInfo("No alert channels on the server")
+			return
+		}
+		rows := make([][]string, len(channels.Channels))
+		for i, ch := range channels.Channels {
+			target := ch.URL
+			if ch.Type == "email" {
+				target = strings.Join(ch.To, ", ")
+			}
+			rows[i] = []string{ch.Name, ch.Type, target}
+		}
+		c.formatter.PrintTable([]string{"Channel", "Type", "Target"}, rows)
+	})
+}
+
+func (c *MonitorCommand) deleteAlertChannel(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: monitor alerts delete-channel <name>")
+	}
+
+	if err := c.client.DeleteAlertChannel(ctx, args[0]); err != nil {
+		return fmt.Errorf("failed to delete alert channel: %w", err)
+	}
+
+	c.formatter.PrintSuccess(fmt.Sprintf("Alert channel deleted: %s", args[0]))
+	return nil
+}
+
+// addAlertSilence silences one rule, or every rule when the rule is "*"
+func (c *MonitorCommand) addAlertSilence(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: monitor alerts silence <rule|*> <duration> [comment]")
+	}
+
+	silence := &client.AlertSilence{Duration: args[1], Comment: strings.Join(args[2:], " ")}
+	if args[0] != "*" {
+		silence.Rule = args[0]
+	}
+
+	saved, err := c.client.AddAlertSilence(ctx, silence)
+	if err != nil {
+		return fmt.Errorf("failed to add silence: %w", err)
+	}
+
+	c.formatter.PrintSuccess(fmt.Sprintf("Silence %s added until %s", saved.ID, formatTime(saved.EndsAt)))
+	return nil
+}
+
+func (c *MonitorCommand) listAlertSilences(ctx context.Context) error {
+	silences, err := c.client.ListAlertSilences(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list silences: %w", err)
+	}
+
+	return c.formatter.PrintResult(silences, func() {
+		if len(silences.Silences) == 0 {
+			c.formatter.PrintInfo("No silences on the server")
+			return
+		}
+		rows := make([][]string, len(silences.Silences))
+		for i, s := range silences.Silences {
+			rule := s.Rule
+			if rule == "" {
+				rule = "*"
+			}
+			rows[i] = []string{s.ID, rule, formatTime(s.StartsAt), formatTime(s.EndsAt), orDash(s.Comment)}
+		}
+		c.formatter.PrintTable([]string{"ID", "Rule", "Starts", "Ends", "Comment"}, rows)
+	})
+}
+
+func (c *MonitorCommand) deleteAlertSilence(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: monitor alerts unsilence <id>")
+	}
+
+	if err := c.client.DeleteAlertSilence(ctx, args[0]); err != nil {
+		return fmt.Errorf("failed to delete silence: %w", err)
+	}
+
+	c.formatter.PrintSuccess(fmt.Sprintf("Silence deleted: %s", args[0]))
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Skpow1234/Peervault/internal/cli/analytics"
@@ -24,7 +25,7 @@ func NewAnalyticsCommand(client *client.Client, formatter *formatter.Formatter,
 		BaseCommand: BaseCommand{
 			name:        "analytics",
 			description: "Analytics and reporting operations",
-			usage:       "analytics [command] [options]",
+			usage:       "analytics [dashboard|viz|visualization|metric|report|ml|model|alert|access|stats|help] [options]",
 			client:      client,
 			formatter:   formatter,
 		},
@@ -55,6 +56,8 @@ func (c *AnalyticsCommand) Execute(ctx context.Context, args []string) error {
 		return c.handleMLCommand(ctx, subArgs)
 	case "alert":
 		return c.handleAlertCommand(ctx, subArgs)
+	case "access":
+		return c.handleAccessCommand(ctx, subArgs)
 	case "stats":
 		return c.handleStatsCommand(ctx, subArgs)
 	case "help":
@@ -132,7 +135,7 @@ func (c *AnalyticsCommand) handleMetricCommand(ctx context.Context, args []strin
 // Report commands
 func (c *AnalyticsCommand) handleReportCommand(ctx context.Context, args []string) error {
 	if len(args) == 0 {
-		return fmt.Errorf("usage: analytics report [create|list|get] [options]")
+		return fmt.Errorf("usage: analytics report [create|list|get|schedule|scheduled|run|unschedule] [options]")
 	}
 
 	subcommand := args[0]
@@ -145,6 +148,14 @@ func (c *AnalyticsCommand) handleReportCommand(ctx context.Context, args []strin
 		return c.listReports(ctx, subArgs)
 	case "get":
 		return c.getReport(ctx, subArgs)
+	case "schedule":
+		return c.scheduleReport(ctx, subArgs)
+	case "scheduled":
+		return c.listScheduledReports(ctx, subArgs)
+	case "run":
+		return c.runReport(ctx, subArgs)
+	case "unschedule":
+		return c.unscheduleReport(ctx, subArgs)
 	default:
 		return fmt.Errorf("unknown report subcommand: %s", subcommand)
 	}
@@ -559,6 +570,126 @@ func (c *AnalyticsCommand) getReport(ctx context.Context, args []string) error {
 	return nil
 }
 
+// Server-side reports, run on the access rollups and stored in PeerVault
+
+// scheduleReport defines a report on the server. Trailing http(s) URLs are
+// webhooks and other trailing arguments email recipients.
+func (c *AnalyticsCommand) scheduleReport(ctx context.Context, args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("usage: analytics report schedule <name> <cron|-> <csv|json|pdf> [key|tenant|peer] [bucket|total] [range] [webhook_url|email]...")
+	}
+
+	report := &client.ScheduledReport{Format: args[2]}
+	if args[1] != "-" {
+		report.Schedule = args[1]
+	}
+	optional := []*string{&report.Dimension, &report.Grouping, &report.Range}
+	rest := args[3:]
+	for i := 0; i < len(optional) && len(rest) > 0; i++ {
+		if strings.HasPrefix(rest[0], "http://") || strings.HasPrefix(rest[0], "https://") || strings.Contains(rest[0], "@") {
+			break
+		}
+		*optional[i] = rest[0]
+		rest = rest[1:]
+	}
+	for _, target := range rest {
+		if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+			report.Webhook = target
+		} else {
+			report.Email = append(report.Email, target)
+		}
+	}
+
+	saved, err := c.client.PutScheduledReport(ctx, args[0], report)
+	if err != nil {
+		return fmt.Errorf("failed to schedule report: %v", err)
+	}
+
+	c.formatter.PrintSuccess(fmt.Sprintf("Report scheduled on the server: %s", saved.Name))
+	c.printScheduledReport(saved)
+	return nil
+}
+
+func (c *AnalyticsCommand) printScheduledReport(report *client.ScheduledReport) {
+	c.formatter.PrintInfo(fmt.Sprintf("  Accesses by %s, grouped by %s over %s: %s", report.Dimension, report.Grouping, report.Range, strings.Join(report.Metrics, ", ")))
+	c.formatter.PrintInfo(fmt.Sprintf("  Format: %s, stored under %s%s/", report.Format, report.Prefix, report.Name))
+	if report.Schedule == "" {
+		c.formatter.PrintInfo("  Schedule: on demand")
+	} else {
+		c.formatter.PrintInfo(fmt.Sprintf("  Schedule: %s (next run %s)", report.Schedule, report.NextRun.Format(time.RFC3339)))
+	}
+	if report.Webhook != "" {
+		c.formatter.PrintInfo(fmt.Sprintf("  Webhook: %s", report.Webhook))
+	}
+	if len(report.Email) > 0 {
+		c.formatter.PrintInfo(fmt.Sprintf("  Email: %s", strings.Join(report.Email, ", ")))
+	}
+	if !report.LastRun.IsZero() {
+		c.formatter.PrintInfo(fmt.Sprintf("  Last run: %s -> %s", report.LastRun.Format(time.RFC3339), report.LastKey))
+	}
+	if report.LastError != "" {
+		c.formatter.PrintInfo(fmt.Sprintf("  Last error: %s", report.LastError))
+	}
+}
+
+func (c *AnalyticsCommand) listScheduledReports(ctx context.Context, _ []string) error {
+	reports, err := c.client.ListScheduledReports(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list server reports: %v", err)
+	}
+
+	if len(reports.Reports) == 0 {
+		c.formatter.PrintInfo("No reports scheduled on the server")
+		return nil
+	}
+
+	c.formatter.PrintInfo(fmt.Sprintf("Found %d server reports:", reports.Total))
+	for i := range reports.Reports {
+		c.formatter.PrintInfo(reports.Reports[i].Name)
+		c.printScheduledReport(&reports.Reports[i])
+		c.formatter.PrintInfo("")
+	}
+
+	return nil
+}
+
+func (c *AnalyticsCommand) runReport(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: analytics report run <name> [output_path]")
+	}
+
+	run, err := c.client.RunScheduledReport(ctx, args[0])
+	if err != nil {
+		return fmt.Errorf("failed to run report: %v", err)
+	}
+
+	c.formatter.PrintSuccess(fmt.Sprintf("Report %s stored as %s (%d bytes)", run.Report, run.Key, run.Size))
+	for _, e := range run.Errors {
+		c.formatter.PrintWarning(fmt.Sprintf("  Delivery failed: %s", e))
+	}
+	if len(args) > 1 {
+		if err := c.client.DownloadFile(ctx, run.Key, args[1]); err != nil {
+			return fmt.Errorf("failed to download report: %v", err)
+		}
+		c.formatter.PrintInfo(fmt.Sprintf("  Saved to %s", args[1]))
+	}
+
+	return nil
+}
+
+func (c *AnalyticsCommand) unscheduleReport(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: analytics report unschedule <name>")
+	}
+
+	if err := c.client.DeleteScheduledReport(ctx, args[0]); err != nil {
+		return fmt.Errorf("failed to delete report: %v", err)
+	}
+
+	c.formatter.PrintSuccess(fmt.Sprintf("Report deleted from the server: %s", args[0]))
+	return nil
+}
+
 // ML operations
 func (c *AnalyticsCommand) createMLModel(ctx context.Context, args []string) error {
 	if len(args) < 5 {
@@ -720,6 +851,140 @@ func (c *AnalyticsCommand) getAlert(ctx context.Context, args []string) error {
 	return nil
 }
 
+// Access commands, fed by the access rollups of the server
+func (c *AnalyticsCommand) handleAccessCommand(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: analytics access [top|viz|widget] <key|tenant|peer> [options]")
+	}
+
+	subcommand := args[0]
+	subArgs := args[1:]
+
+	switch subcommand {
+	case "top":
+		return c.topAccessed(ctx, subArgs)
+	case "viz":
+		return c.visualizeAccess(ctx, subArgs)
+	case "widget":
+		return c.accessWidget(ctx, subArgs)
+	default:
+		return fmt.Errorf("unknown access subcommand: %s", subcommand)
+	}
+}
+
+// accessWindow parses the optional time window of an access command, 24h
+// by default
+func accessWindow(args []string, i int) (time.Time, error) {
+	window := 24 * time.Hour
+	if len(args) > i {
+		var err error
+		if window, err = time.ParseDuration(args[i]); err != nil {
+			return time.Time{}, fmt.Errorf("invalid window %q: %v", args[i], err)
+		}
+	}
+	return time.Now().Add(-window), nil
+}
+
+// accessChart charts the accesses of the window by dimension
+func (c *AnalyticsCommand) accessChart(ctx context.Context, dimension string, from time.Time) (*analytics.ChartData, error) {
+	rollups, err := c.client.GetAccessRollups(ctx, dimension, "", from, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access rollups: %v", err)
+	}
+	return analytics.AccessChart(rollups, 5), nil
+}
+
+func (c *AnalyticsCommand) topAccessed(ctx context.Context, args []string) error {
+	from, err := accessWindow(args, 1)
+	if err != nil {
+		return err
+	}
+	limit := 10
+	if len(args) > 2 {
+		if limit, err = strconv.Atoi(args[2]); err != nil {
+			return fmt.Errorf("invalid limit %q", args[2])
+		}
+	}
+
+	top, err := c.client.GetTopAccessed(ctx, args[0], from, time.Time{}, limit)
+	if err != nil {
+		return fmt.Errorf("failed to get access analytics: %v", err)
+	}
+	if len(top.Totals) == 0 {
+		c.formatter.PrintInfo("No accesses recorded")
+		return nil
+	}
+
+	c.formatter.PrintInfo(fmt.Sprintf("Most accessed by %s since %s:", top.Dimension, from.Format(time.RFC3339)))
+	for _, t := range top.Totals {
+		c.formatter.PrintInfo(fmt.Sprintf("  %s", t.Value))
+		c.formatter.PrintInfo(fmt.Sprintf("    Reads: %d (%d bytes)  Writes: %d (%d bytes)  Deletes: %d",
+			t.Reads, t.BytesRead, t.Writes, t.BytesWritten, t.Deletes))
+	}
+
+	return nil
+}
+
+func (c *AnalyticsCommand) visualizeAccess(ctx context.Context, args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("usage: analytics access viz <key|tenant|peer> <name> <created_by> [window]")
+	}
+
+	dimension, name, createdBy := args[0], args[1], args[2]
+	from, err := accessWindow(args, 3)
+	if err != nil {
+		return err
+	}
+	chartData, err := c.accessChart(ctx, dimension, from)
+	if err != nil {
+		return err
+	}
+
+	description := fmt.Sprintf("Accesses by %s since %s", dimension, from.Format(time.RFC3339))
+	config := map[string]interface{}{"source": "access", "dimension": dimension}
+	visualization, err := c.visualizationManager.CreateVisualization(ctx, name, description, "chart", createdBy, chartData, config, false, []string{"access"})
+	if err != nil {
+		return fmt.Errorf("failed to create visualization: %v", err)
+	}
+
+	c.formatter.PrintSuccess(fmt.Sprintf("Visualization created successfully: %s", visualization.ID))
+	c.formatter.PrintInfo(fmt.Sprintf("  Series: %d", len(chartData.Datasets)))
+	c.formatter.PrintInfo(fmt.Sprintf("  Data Points: %d", len(chartData.Labels)))
+
+	return nil
+}
+
+func (c *AnalyticsCommand) accessWidget(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: analytics access widget <dashboard_id> <key|tenant|peer> [window]")
+	}
+
+	dashboardID, dimension := args[0], args[1]
+	from, err := accessWindow(args, 2)
+	if err != nil {
+		return err
+	}
+	chartData, err := c.accessChart(ctx, dimension, from)
+	if err != nil {
+		return err
+	}
+
+	config := map[string]interface{}{"chart_type": "line", "source": "access", "dimension": dimension}
+	title := fmt.Sprintf("Accesses by %s", dimension)
+	widget, err := c.dashboardManager.AddWidget(ctx, dashboardID, "chart", title, "Reads, writes and deletes served by the node", config, &analytics.Position{}, &analytics.Size{Width: 6, Height: 4})
+	if err != nil {
+		return fmt.Errorf("failed to add widget: %v", err)
+	}
+	if err := c.dashboardManager.SetWidgetData(ctx, widget.ID, map[string]interface{}{"chart": chartData}); err != nil {
+		return fmt.Errorf("failed to set widget data: %v", err)
+	}
+
+	c.formatter.PrintSuccess(fmt.Sprintf("Widget added successfully: %s", widget.ID))
+	c.formatter.PrintInfo(fmt.Sprintf("  Series: %d", len(chartData.Datasets)))
+
+	return nil
+}
+
 // Stats operation
 func (c *AnalyticsCommand) getAnalyticsStats(ctx context.Context) error {
 	dashboardStats, err := c.dashboardManager.GetAnalyticsStats(ctx)
@@ -766,8 +1031,10 @@ func (c *AnalyticsCommand) showHelp() error {
 	c.formatter.PrintInfo("  viz [create|list|get]                  - Data visualization")
 	c.formatter.PrintInfo("  metric [create|update|list]            - Metrics management")
 	c.formatter.PrintInfo("  report [create|list|get]               - Report generation")
+	c.formatter.PrintInfo("  report [schedule|scheduled|run|unschedule] - Reports run and stored by the server")
 	c.formatter.PrintInfo("  ml [create|train|predict|list]         - Machine learning")
 	c.formatter.PrintInfo("  alert [create|list|get]                - Alert management")
+	c.formatter.PrintInfo("  access [top|viz|widget]                - Access analytics of the server")
 	c.formatter.PrintInfo("  stats                                  - Show analytics statistics")
 	c.formatter.PrintInfo("  help                                   - Show this help")
 	c.formatter.PrintInfo("")
@@ -777,6 +1044,10 @@ func (c *AnalyticsCommand) showHelp() error {
 	c.formatter.PrintInfo("  analytics metric create 'Revenue' 'USD' 15000.50 20000 25000")
 	c.formatter.PrintInfo("  analytics ml create 'Sales Predictor' 'Predicts sales' regression linear 1.0 user123")
 	c.formatter.PrintInfo("  analytics alert create 'High CPU' 'CPU usage alert' threshold high user123 80")
+	c.formatter.PrintInfo("  analytics access top tenant 168h 5")
+	c.formatter.PrintInfo("  analytics access widget <dashboard_id> key 24h")
+	c.formatter.PrintInfo("  analytics report schedule weekly-tenants '0 6 * * 1' pdf tenant total 168h ops@example.com")
+	c.formatter.PrintInfo("  analytics report run weekly-tenants ./weekly.pdf")
 	c.formatter.PrintInfo("  analytics stats")
 
 	return nil
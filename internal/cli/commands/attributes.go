@@ -0,0 +1,180 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// TagCommand manages tags attached to stored files
+type TagCommand struct {
+	BaseCommand
+}
+
+// NewTagCommand creates a new tag command
+func NewTagCommand(client *client.Client, formatter *formatter.Formatter) *TagCommand {
+	return &TagCommand{
+		BaseCommand: BaseCommand{
+			name:        "tag",
+			description: "Add, remove or search file tags",
+			usage:       "tag [add|remove] <file_id> <tag> [tag...] | tag find <tag> [tag...]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the tag command
+func (c *TagCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: %s", c.usage)
+	}
+
+	subcommand := strings.ToLower(args[0])
+	switch subcommand {
+	case "add", "remove":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: tag %s <file_id> <tag> [tag...]", subcommand)
+		}
+		patch := &client.FileAttributePatch{}
+		if subcommand == "add" {
+			patch.AddTags = args[2:]
+		} else {
+			patch.RemoveTags = args[2:]
+		}
+		file, err := c.client.PatchFileMetadata(ctx, args[1], patch)
+		if err != nil {
+			return fmt.Errorf("failed to update tags: %w", err)
+		}
+		c.formatter.PrintSuccess(fmt.Sprintf("Tags updated for %s", args[1]))
+		return c.formatter.PrintFileInfo(file)
+	case "find":
+		files, err := c.client.SearchFiles(ctx, args[1:], nil)
+		if err != nil {
+			return fmt.Errorf("failed to search files: %w", err)
+		}
+		return c.formatter.PrintFileList(files)
+	default:
+		return fmt.Errorf("unknown subcommand: %s", subcommand)
+	}
+}
+
+// AttrCommand manages custom key/value metadata attached to stored files
+type AttrCommand struct {
+	BaseCommand
+}
+
+// NewAttrCommand creates a new attr command
+func NewAttrCommand(client *client.Client, formatter *formatter.Formatter) *AttrCommand {
+	return &AttrCommand{
+		BaseCommand: BaseCommand{
+			name:        "attr",
+			description: "Set, remove or search custom file metadata",
+			usage:       "attr set <file_id> key=value [key=value...] | attr unset <file_id> <key> [key...] | attr find key=value [key=value...]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the attr command
+func (c *AttrCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: %s", c.usage)
+	}
+
+	subcommand := strings.ToLower(args[0])
+	switch subcommand {
+	case "set":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: attr set <file_id> key=value [key=value...]")
+		}
+		pairs, err := parseKeyValues(args[2:])
+		if err != nil {
+			return err
+		}
+		return c.patch(ctx, args[1], &client.FileAttributePatch{Set: pairs})
+	case "unset":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: attr unset <file_id> <key> [key...]")
+		}
+		return c.patch(ctx, args[1], &client.FileAttributePatch{Remove: args[2:]})
+	case "find":
+		pairs, err := parseKeyValues(args[1:])
+		if err != nil {
+			return err
+		}
+		files, err := c.client.SearchFiles(ctx, nil, pairs)
+		if err != nil {
+			return fmt.Errorf("failed to search files: %w", err)
+		}
+		return c.formatter.PrintFileList(files)
+	default:
+		return fmt.Errorf("unknown subcommand: %s", subcommand)
+	}
+}
+
+func (c *AttrCommand) patch(ctx context.Context, fileID string, patch *client.FileAttributePatch) error {
+	file, err := c.client.PatchFileMetadata(ctx, fileID, patch)
+	if err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	c.formatter.PrintSuccess(fmt.Sprintf("Metadata updated for %s", fileID))
+	return c.formatter.PrintFileInfo(file)
+}
+
+// parseKeyValues parses arguments of the form key=value
+func parseKeyValues(args []string) (map[string]string, error) {
+	pairs := make(map[string]string, len(args))
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid key=value pair: %s", arg)
+		}
+		pairs[key] = value
+	}
+	return pairs, nil
+}
+
+// parseAttributeFlags extracts --tag and --meta options from command arguments
+// and returns the remaining positional arguments
+func parseAttributeFlags(args []string) ([]string, []string, map[string]string, error) {
+	var positional, tags []string
+	var meta map[string]string
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--tag", "--tags":
+			if i+1 >= len(args) {
+				return nil, nil, nil, fmt.Errorf("%s requires a value", args[i])
+			}
+			i++
+			for _, tag := range strings.Split(args[i], ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					tags = append(tags, tag)
+				}
+			}
+		case "--meta":
+			if i+1 >= len(args) {
+				return nil, nil, nil, fmt.Errorf("--meta requires a key=value pair")
+			}
+			i++
+			pairs, err := parseKeyValues([]string{args[i]})
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if meta == nil {
+				meta = make(map[string]string)
+			}
+			for k, v := range pairs {
+				meta[k] = v
+			}
+		default:
+			positional = append(positional, args[i])
+		}
+	}
+	return positional, tags, meta, nil
+}
@@ -4,16 +4,15 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
-	"github.com/Skpow1234/Peervault/internal/cli/backup"
 	"github.com/Skpow1234/Peervault/internal/cli/client"
 	"github.com/Skpow1234/Peervault/internal/cli/formatter"
 )
 
-// BackupCommand manages backup and recovery operations
+// BackupCommand manages backup jobs and their snapshots
 type BackupCommand struct {
 	BaseCommand
-	backupManager *backup.Manager
 }
 
 // NewBackupCommand creates a new backup command
@@ -21,366 +20,301 @@ func NewBackupCommand(client *client.Client, formatter *formatter.Formatter) *Ba
 	return &BackupCommand{
 		BaseCommand: BaseCommand{
 			name:        "backup",
-			description: "Manage backup and recovery operations",
-			usage:       "backup [create|list|restore|delete|schedule|cleanup] [options]",
+			description: "Run backup jobs and inspect, verify or delete snapshots",
+			usage:       "backup [jobs|run <job> [--full]|list <job>|show <job> <snapshot>|verify <job> <snapshot>|delete <job> <snapshot>]",
 			client:      client,
 			formatter:   formatter,
 		},
-		backupManager: backup.New(client, "./config"),
 	}
 }
 
 // Execute executes the backup command
 func (c *BackupCommand) Execute(ctx context.Context, args []string) error {
 	if len(args) == 0 {
-		return c.showBackupHelp()
+		return c.listJobs(ctx)
 	}
 
 	subcommand := strings.ToLower(args[0])
 	switch subcommand {
-	case "create":
-		return c.createBackup(args[1:])
-	case "list":
-		return c.listBackups()
-	case "restore":
-		return c.restoreBackup(args[1:])
+	case "jobs", "status":
+		return c.listJobs(ctx)
+	case "run", "create":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: backup run <job> [--full]")
+		}
+		return c.run(ctx, args[1], contains(args[2:], "--full"))
+	case "list", "snapshots":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: backup list <job>")
+		}
+		return c.listSnapshots(ctx, args[1])
+	case "show":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: backup show <job> <snapshot>")
+		}
+		return c.showSnapshot(ctx, args[1], args[2])
+	case "verify":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: backup verify <job> <snapshot>")
+		}
+		return c.verify(ctx, args[1], args[2])
 	case "delete":
-		return c.deleteBackup(args[1:])
-	case "schedule":
-		return c.scheduleBackup(args[1:])
-	case "cleanup":
-		return c.cleanupBackups(args[1:])
-	case "status":
-		return c.showBackupStatus(args[1:])
+		if len(args) < 3 {
+			return fmt.Errorf("usage: backup delete <job> <snapshot>")
+		}
+		return c.delete(ctx, args[1], args[2])
+	case "restore":
+		return NewRestoreCommand(c.client, c.formatter).Execute(ctx, args[1:])
 	default:
 		return fmt.Errorf("unknown subcommand: %s", subcommand)
 	}
 }
 
-// createBackup creates a new backup
-func (c *BackupCommand) createBackup(args []string) error {
-	if len(args) < 2 {
-		return fmt.Errorf("usage: backup create <name> <type> [options]")
-	}
-
-	name := args[0]
-	backupType := args[1]
-
-	// Validate backup type
-	validTypes := []string{"full", "incremental", "differential"}
-	if !contains(validTypes, backupType) {
-		return fmt.Errorf("invalid backup type: %s. Valid types: %s", backupType, strings.Join(validTypes, ", "))
-	}
-
-	// Parse options
-	config := &backup.BackupConfig{
-		Name:        name,
-		Type:        backupType,
-		Compression: true,
-		Encryption:  false,
-		Destination: "./backups",
-		Include:     []string{},
-		Exclude:     []string{},
-		Options:     make(map[string]string),
+// listJobs prints the configured jobs and how their last run went
+func (c *BackupCommand) listJobs(ctx context.Context) error {
+	jobs, err := c.client.ListBackupJobs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list backup jobs: %w", err)
 	}
-
-	// Parse additional options
-	for i := 2; i < len(args); i += 2 {
-		if i+1 >= len(args) {
-			break
+	return c.formatter.PrintResult(jobs, func() {
+		if len(jobs.Jobs) == 0 {
+			c.formatter.PrintInfo("No backup jobs configured; start the API with -backup-config")
+			return
 		}
 
-		option := args[i]
-		value := args[i+1]
-
-		switch option {
-		case "--compression":
-			config.Compression = value == "true"
-		case "--encryption":
-			config.Encryption = value == "true"
-		case "--destination":
-			config.Destination = value
-		case "--include":
-			config.Include = strings.Split(value, ",")
-		case "--exclude":
-			config.Exclude = strings.Split(value, ",")
+		rows := make([][]string, len(jobs.Jobs))
+		for i, j := range jobs.Jobs {
+			schedule := orDash(j.Schedule)
+			if j.FullSchedule != "" {
+				schedule += " (full: " + j.FullSchedule + ")"
+			}
+			last := orDash(j.LastSnapshot)
+			if j.Running {
+				last = "running"
+			} else if j.LastError != "" {
+				last = "failed: " + j.LastError
+			}
+			rows[i] = []string{j.Name, orDash(j.Namespace), j.Target, schedule, formatTime(j.NextRun), last}
 		}
-	}
-
-	c.formatter.PrintInfo(fmt.Sprintf("Creating %s backup: %s", backupType, name))
+		c.formatter.PrintTable([]string{"Job", "Namespace", "Target", "Schedule", "Next Run", "Last Snapshot"}, rows)
+	})
+}
 
-	// Create backup
-	backupObj, err := c.backupManager.CreateBackup(config)
-	if err != nil {
-		return fmt.Errorf("failed to create backup: %w", err)
+// run starts a backup; it completes in the background on the server
+func (c *BackupCommand) run(ctx context.Context, job string, full bool) error {
+	backupType := "incremental"
+	if full {
+		backupType = "full"
 	}
-
-	// Start backup
-	err = c.backupManager.StartBackup(backupObj.ID, config)
-	if err != nil {
+	if err := c.client.RunBackup(ctx, job, backupType); err != nil {
 		return fmt.Errorf("failed to start backup: %w", err)
 	}
-
-	c.formatter.PrintSuccess(fmt.Sprintf("Backup created and started: %s", backupObj.ID))
-	c.formatter.PrintInfo(fmt.Sprintf("Backup file: %s", backupObj.FilePath))
-
+	c.formatter.PrintSuccess(fmt.Sprintf("Started %s backup of %s", backupType, job))
+	c.formatter.PrintInfo("Follow its progress with: backup jobs")
 	return nil
 }
 
-// listBackups lists all backups
-func (c *BackupCommand) listBackups() error {
-	backups := c.backupManager.ListBackups()
-
-	if len(backups) == 0 {
-		c.formatter.PrintInfo("No backups found")
-		return nil
+// listSnapshots prints a job's snapshots, oldest first
+func (c *BackupCommand) listSnapshots(ctx context.Context, job string) error {
+	snaps, err := c.client.ListBackupSnapshots(ctx, job)
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
 	}
-
-	c.formatter.PrintInfo("Backups:")
-	fmt.Println(strings.Repeat("=", 120))
-	fmt.Printf("%-15s %-20s %-12s %-12s %-20s %-10s %-15s %-10s\n", "ID", "Name", "Type", "Status", "Created", "Size", "File Count", "Duration")
-	fmt.Println(strings.Repeat("-", 120))
-
-	for _, backup := range backups {
-		duration := ""
-		if !backup.StartedAt.IsZero() && !backup.CompletedAt.IsZero() {
-			duration = backup.CompletedAt.Sub(backup.StartedAt).String()
-		} else if !backup.StartedAt.IsZero() {
-			duration = "Running..."
+	return c.formatter.PrintResult(snaps, func() {
+		if len(snaps.Snapshots) == 0 {
+			c.formatter.PrintInfo(fmt.Sprintf("No snapshots for %s yet", job))
+			return
 		}
 
-		size := formatBytes(backup.Size)
-
-		fmt.Printf("%-15s %-20s %-12s %-12s %-20s %-10s %-15d %-10s\n",
-			backup.ID[:12],
-			backup.Name,
-			backup.Type,
-			backup.Status,
-			backup.CreatedAt.Format("2006-01-02 15:04:05"),
-			size,
-			backup.FileCount,
-			duration,
-		)
-	}
-
-	return nil
+		rows := make([][]string, len(snaps.Snapshots))
+		for i, s := range snaps.Snapshots {
+			rows[i] = []string{
+				s.ID,
+				s.Type,
+				formatTime(s.CompletedAt),
+				fmt.Sprintf("%d", s.Files),
+				c.formatter.FormatBytes(s.Size),
+				fmt.Sprintf("%d (%s)", s.Changed, c.formatter.FormatBytes(s.Uploaded)),
+			}
+		}
+		c.formatter.PrintTable([]string{"Snapshot", "Type", "Completed", "Files", "Size", "Changed"}, rows)
+	})
 }
 
-// restoreBackup restores from a backup
-func (c *BackupCommand) restoreBackup(args []string) error {
-	if len(args) < 2 {
-		return fmt.Errorf("usage: backup restore <backup_id> <destination>")
-	}
-
-	backupID := args[0]
-	destination := args[1]
-
-	c.formatter.PrintInfo(fmt.Sprintf("Restoring backup %s to %s", backupID, destination))
-
-	// Get backup info
-	backupObj, err := c.backupManager.GetBackup(backupID)
+// showSnapshot prints a snapshot and the files it holds
+func (c *BackupCommand) showSnapshot(ctx context.Context, job, id string) error {
+	snap, err := c.client.GetBackupSnapshot(ctx, job, id)
 	if err != nil {
-		return fmt.Errorf("backup not found: %w", err)
-	}
-
-	if backupObj.Status != "completed" {
-		return fmt.Errorf("backup is not completed: %s", backupObj.Status)
-	}
+		return fmt.Errorf("failed to get snapshot: %w", err)
+	}
+
+	return c.formatter.PrintResult(snap, func() {
+		c.formatter.PrintHeader(fmt.Sprintf("Snapshot %s (%s)", snap.ID, snap.Type))
+		c.formatter.PrintTable([]string{"Field", "Value"}, [][]string{
+			{"Job", snap.Job},
+			{"Namespace", orDash(snap.Namespace)},
+			{"Parent", orDash(snap.Parent)},
+			{"Started", formatTime(snap.StartedAt)},
+			{"Completed", formatTime(snap.CompletedAt)},
+			{"Files", fmt.Sprintf("%d (%s)", snap.Files, c.formatter.FormatBytes(snap.Size))},
+			{"Changed", fmt.Sprintf("%d (%s uploaded)", snap.Changed, c.formatter.FormatBytes(snap.Uploaded))},
+		})
+
+		if len(snap.Entries) > 0 {
+			rows := make([][]string, len(snap.Entries))
+			for i, e := range snap.Entries {
+				rows[i] = []string{e.Key, e.Name, c.formatter.FormatBytes(e.Size), e.Snapshot}
+			}
+			c.formatter.PrintTable([]string{"Key", "Name", "Size", "Stored In"}, rows)
+		}
+	})
+}
 
-	// Restore backup
-	err = c.backupManager.RestoreBackup(backupID, destination)
+// verify reads back a snapshot and reports damaged or missing blobs
+func (c *BackupCommand) verify(ctx context.Context, job, id string) error {
+	report, err := c.client.VerifyBackupSnapshot(ctx, job, id)
 	if err != nil {
-		return fmt.Errorf("failed to restore backup: %w", err)
+		return fmt.Errorf("failed to verify snapshot: %w", err)
 	}
 
-	c.formatter.PrintSuccess("Backup restored successfully")
-	c.formatter.PrintInfo(fmt.Sprintf("Restored %d files to %s", backupObj.FileCount, destination))
+	err = c.formatter.PrintResult(report, func() {
+		if report.Healthy {
+			c.formatter.PrintSuccess(fmt.Sprintf("Snapshot %s is intact: %d file(s), %s checked",
+				id, report.Checked, c.formatter.FormatBytes(report.Bytes)))
+			return
+		}
 
-	return nil
+		rows := make([][]string, 0, len(report.Missing)+len(report.Corrupt))
+		for _, key := range report.Missing {
+			rows = append(rows, []string{key, "missing"})
+		}
+		for _, key := range report.Corrupt {
+			rows = append(rows, []string{key, "checksum mismatch"})
+		}
+		c.formatter.PrintTable([]string{"Key", "Problem"}, rows)
+	})
+	if err != nil || report.Healthy {
+		return err
+	}
+	return fmt.Errorf("snapshot %s failed verification: %d missing, %d corrupt", id, len(report.Missing), len(report.Corrupt))
 }
 
-// deleteBackup deletes a backup
-func (c *BackupCommand) deleteBackup(args []string) error {
-	if len(args) < 1 {
-		return fmt.Errorf("usage: backup delete <backup_id>")
+// delete removes a snapshot no later snapshot depends on
+func (c *BackupCommand) delete(ctx context.Context, job, id string) error {
+	if err := c.client.DeleteBackupSnapshot(ctx, job, id); err != nil {
+		return fmt.Errorf("failed to delete snapshot: %w", err)
 	}
-
-	backupID := args[0]
-
-	c.formatter.PrintInfo(fmt.Sprintf("Deleting backup: %s", backupID))
-
-	err := c.backupManager.DeleteBackup(backupID)
-	if err != nil {
-		return fmt.Errorf("failed to delete backup: %w", err)
-	}
-
-	c.formatter.PrintSuccess("Backup deleted successfully")
-
+	c.formatter.PrintSuccess(fmt.Sprintf("Deleted snapshot %s of %s", id, job))
 	return nil
 }
 
-// scheduleBackup schedules a backup
-func (c *BackupCommand) scheduleBackup(args []string) error {
-	if len(args) < 3 {
-		return fmt.Errorf("usage: backup schedule <name> <type> <schedule> [options]")
-	}
-
-	name := args[0]
-	backupType := args[1]
-	schedule := args[2]
-
-	// Validate backup type
-	validTypes := []string{"full", "incremental", "differential"}
-	if !contains(validTypes, backupType) {
-		return fmt.Errorf("invalid backup type: %s. Valid types: %s", backupType, strings.Join(validTypes, ", "))
-	}
+// RestoreCommand restores files from backup snapshots
+type RestoreCommand struct {
+	BaseCommand
+}
 
-	config := &backup.BackupConfig{
-		Name:        name,
-		Type:        backupType,
-		Schedule:    schedule,
-		Retention:   30, // Default 30 days
-		Compression: true,
-		Encryption:  false,
-		Destination: "./backups",
-		Include:     []string{},
-		Exclude:     []string{},
-		Options:     make(map[string]string),
+// NewRestoreCommand creates a new restore command
+func NewRestoreCommand(client *client.Client, formatter *formatter.Formatter) *RestoreCommand {
+	return &RestoreCommand{
+		BaseCommand: BaseCommand{
+			name:        "restore",
+			description: "Restore files from a backup snapshot or point in time",
+			usage:       "restore <job> [--snapshot <id>|--at <time>] [--prefix <prefix>] [--overwrite]",
+			client:      client,
+			formatter:   formatter,
+		},
 	}
+}
 
-	// Parse additional options
-	for i := 3; i < len(args); i += 2 {
-		if i+1 >= len(args) {
-			break
-		}
-
-		option := args[i]
-		value := args[i+1]
-
-		switch option {
-		case "--retention":
-			if days, err := fmt.Sscanf(value, "%d", &config.Retention); err != nil || days != 1 {
-				return fmt.Errorf("invalid retention days: %s", value)
+// Execute executes the restore command. --at takes an RFC 3339 time or a
+// duration such as 6h meaning that long ago; without --snapshot or --at the
+// latest snapshot is restored.
+func (c *RestoreCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s", c.usage)
+	}
+
+	job := args[0]
+	req := &client.BackupRestoreRequest{}
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "--overwrite":
+			req.Overwrite = true
+		case "--snapshot", "--at", "--prefix":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a value", args[i])
 			}
-		case "--compression":
-			config.Compression = value == "true"
-		case "--encryption":
-			config.Encryption = value == "true"
-		case "--destination":
-			config.Destination = value
-		case "--include":
-			config.Include = strings.Split(value, ",")
-		case "--exclude":
-			config.Exclude = strings.Split(value, ",")
+			value := args[i+1]
+			switch args[i] {
+			case "--snapshot":
+				req.Snapshot = value
+			case "--prefix":
+				req.Prefix = value
+			case "--at":
+				at, err := parseRestorePoint(value, time.Now())
+				if err != nil {
+					return err
+				}
+				req.At = at.Format(time.RFC3339)
+			}
+			i++
+		default:
+			return fmt.Errorf("unknown option: %s", args[i])
 		}
 	}
 
-	c.formatter.PrintInfo(fmt.Sprintf("Scheduling %s backup: %s", backupType, name))
-
-	err := c.backupManager.ScheduleBackup(config)
+	report, err := c.client.RestoreBackup(ctx, job, req)
 	if err != nil {
-		return fmt.Errorf("failed to schedule backup: %w", err)
+		return fmt.Errorf("restore failed: %w", err)
 	}
 
-	c.formatter.PrintSuccess("Backup scheduled successfully")
-	c.formatter.PrintInfo(fmt.Sprintf("Schedule: %s", schedule))
-	c.formatter.PrintInfo(fmt.Sprintf("Retention: %d days", config.Retention))
-
-	return nil
-}
-
-// cleanupBackups cleans up old backups
-func (c *BackupCommand) cleanupBackups(args []string) error {
-	retentionDays := 30 // Default
-
-	if len(args) > 0 {
-		if days, err := fmt.Sscanf(args[0], "%d", &retentionDays); err != nil || days != 1 {
-			return fmt.Errorf("invalid retention days: %s", args[0])
-		}
-	}
-
-	c.formatter.PrintInfo(fmt.Sprintf("Cleaning up backups older than %d days", retentionDays))
-
-	err := c.backupManager.CleanupOldBackups(retentionDays)
+	err = c.formatter.PrintResult(report, func() {
+		c.formatter.PrintTable([]string{"Field", "Value"}, [][]string{
+			{"Snapshot", report.Snapshot},
+			{"Restored", fmt.Sprintf("%d (%s)", report.Restored, c.formatter.FormatBytes(report.Bytes))},
+			{"Skipped (exists)", fmt.Sprintf("%d", report.Skipped)},
+			{"Failed", fmt.Sprintf("%d", report.Failed)},
+		})
+	})
 	if err != nil {
-		return fmt.Errorf("failed to cleanup backups: %w", err)
+		return err
 	}
-
-	c.formatter.PrintSuccess("Backup cleanup completed")
-
-	return nil
-}
-
-// showBackupStatus shows backup status
-func (c *BackupCommand) showBackupStatus(args []string) error {
-	if len(args) < 1 {
-		return fmt.Errorf("usage: backup status <backup_id>")
+	for _, msg := range report.Errors {
+		c.formatter.PrintWarning(msg)
 	}
-
-	backupID := args[0]
-
-	backupObj, err := c.backupManager.GetBackup(backupID)
-	if err != nil {
-		return fmt.Errorf("backup not found: %w", err)
+	if report.Skipped > 0 && !req.Overwrite {
+		c.formatter.PrintInfo("Existing files were kept; re-run with --overwrite to replace them")
 	}
-
-	c.formatter.PrintInfo(fmt.Sprintf("Backup Status: %s", backupObj.Name))
-	fmt.Println(strings.Repeat("=", 50))
-	fmt.Printf("ID: %s\n", backupObj.ID)
-	fmt.Printf("Type: %s\n", backupObj.Type)
-	fmt.Printf("Status: %s\n", backupObj.Status)
-	fmt.Printf("Created: %s\n", backupObj.CreatedAt.Format("2006-01-02 15:04:05"))
-
-	if !backupObj.StartedAt.IsZero() {
-		fmt.Printf("Started: %s\n", backupObj.StartedAt.Format("2006-01-02 15:04:05"))
+	if report.Failed > 0 {
+		return fmt.Errorf("%d file(s) could not be restored", report.Failed)
 	}
+	c.formatter.PrintSuccess(fmt.Sprintf("Restored %d file(s) from %s", report.Restored, report.Snapshot))
+	return nil
+}
 
-	if !backupObj.CompletedAt.IsZero() {
-		fmt.Printf("Completed: %s\n", backupObj.CompletedAt.Format("2006-01-02 15:04:05"))
-		fmt.Printf("Duration: %s\n", backupObj.CompletedAt.Sub(backupObj.StartedAt))
+// parseRestorePoint accepts an RFC 3339 time or a duration before now
+func parseRestorePoint(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
 	}
-
-	fmt.Printf("Size: %s\n", formatBytes(backupObj.Size))
-	fmt.Printf("File Count: %d\n", backupObj.FileCount)
-	fmt.Printf("File Path: %s\n", backupObj.FilePath)
-
-	if backupObj.Error != "" {
-		fmt.Printf("Error: %s\n", backupObj.Error)
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return now.Add(-d), nil
 	}
+	return time.Time{}, fmt.Errorf("invalid --at %q: use an RFC 3339 time or a duration such as 6h", value)
+}
 
-	// Show metadata
-	if len(backupObj.Metadata) > 0 {
-		fmt.Println("\nMetadata:")
-		for key, value := range backupObj.Metadata {
-			fmt.Printf("  %s: %v\n", key, value)
-		}
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
 	}
-
-	return nil
+	return t.Local().Format("2006-01-02 15:04:05")
 }
 
-// showBackupHelp shows backup help
-func (c *BackupCommand) showBackupHelp() error {
-	c.formatter.PrintInfo("Backup Commands:")
-	fmt.Println("  create <name> <type> [options]     - Create a new backup")
-	fmt.Println("  list                                - List all backups")
-	fmt.Println("  restore <backup_id> <destination>  - Restore from backup")
-	fmt.Println("  delete <backup_id>                  - Delete a backup")
-	fmt.Println("  schedule <name> <type> <schedule>   - Schedule a backup")
-	fmt.Println("  cleanup [retention_days]            - Cleanup old backups")
-	fmt.Println("  status <backup_id>                  - Show backup status")
-	fmt.Println("\nBackup Types:")
-	fmt.Println("  full        - Complete backup of all files")
-	fmt.Println("  incremental - Backup of files changed since last backup")
-	fmt.Println("  differential - Backup of files changed since last full backup")
-	fmt.Println("\nOptions:")
-	fmt.Println("  --compression true/false    - Enable/disable compression")
-	fmt.Println("  --encryption true/false     - Enable/disable encryption")
-	fmt.Println("  --destination <path>        - Backup destination directory")
-	fmt.Println("  --include <patterns>        - Include file patterns (comma-separated)")
-	fmt.Println("  --exclude <patterns>        - Exclude file patterns (comma-separated)")
-	fmt.Println("  --retention <days>          - Retention period in days")
-	return nil
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
 }
 
 // Utility functions
@@ -392,16 +326,3 @@ func contains(slice []string, item string) bool {
 	}
 	return false
 }
-
-func formatBytes(bytes int64) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
-	}
-	div, exp := int64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
-	}
-	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
-}
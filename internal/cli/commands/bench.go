@@ -0,0 +1,128 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Skpow1234/Peervault/internal/bench"
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+	pvclient "github.com/Skpow1234/Peervault/pkg/client"
+)
+
+// BenchCommand benchmarks the cluster the CLI is connected to
+type BenchCommand struct {
+	BaseCommand
+}
+
+// NewBenchCommand creates a new bench command
+func NewBenchCommand(client *client.Client, formatter *formatter.Formatter) *BenchCommand {
+	return &BenchCommand{
+		BaseCommand: BaseCommand{
+			name:        "bench",
+			description: "Benchmark the cluster with a workload of reads and writes",
+			usage:       "bench [--duration 30s] [--ops N] [--concurrency 8] [--read-ratio 0.8] [--sizes 4KiB:70,1MiB:30] [--out report.json] [--baseline report.json] [--tolerance 0.1]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the bench command
+func (c *BenchCommand) Execute(ctx context.Context, args []string) error {
+	workload := bench.DefaultWorkload()
+	var out, baseline string
+	var tolerance float64
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	workload.RegisterFlags(flags)
+	flags.StringVar(&out, "out", "", "Write the JSON report to this file")
+	flags.StringVar(&baseline, "baseline", "", "Fail if the run is worse than this earlier report")
+	flags.Float64Var(&tolerance, "tolerance", 0.1, "Share by which figures may be worse than the baseline")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("usage: %s: %v", c.usage, err)
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("usage: %s", c.usage)
+	}
+	if err := workload.Validate(); err != nil {
+		return fmt.Errorf("usage: %v", err)
+	}
+
+	var base *bench.Report
+	if baseline != "" {
+		var err error
+		if base, err = bench.LoadReport(baseline); err != nil {
+			return fmt.Errorf("failed to load baseline: %w", err)
+		}
+	}
+
+	api, err := pvclient.New(c.client.ServerURL(),
+		pvclient.WithToken(c.client.AuthToken()),
+		pvclient.WithHTTPClient(&http.Client{Transport: c.client.Transport()}),
+		pvclient.WithUserAgent("peervault-cli-bench"),
+	)
+	if err != nil {
+		return err
+	}
+	c.formatter.PrintInfo(fmt.Sprintf("Benchmarking %s with %d connections...", c.client.ServerURL(), workload.Concurrency))
+	report, err := bench.NewRunner(bench.ClientTarget{Client: api}, nil).Run(ctx, workload)
+	if err != nil {
+		return err
+	}
+	if out != "" {
+		if err := report.WriteFile(out); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+	}
+
+	if err := c.formatter.PrintResult(report, func() { c.printReport(report) }); err != nil {
+		return err
+	}
+	if base == nil {
+		return nil
+	}
+	regressions := bench.Compare(base, report, tolerance)
+	for _, r := range regressions {
+		c.formatter.PrintWarning("Regression: " + r.String())
+	}
+	if len(regressions) > 0 {
+		return fmt.Errorf("%d figures regressed against %s", len(regressions), baseline)
+	}
+	return nil
+}
+
+// printReport prints a report as a table
+func (c *BenchCommand) printReport(report *bench.Report) {
+	rows := make([][]string, 0, 3)
+	for _, op := range []struct {
+		name  string
+		stats bench.OpStats
+	}{
+		{"read", report.Read},
+		{"write", report.Write},
+		{"total", report.Total},
+	} {
+		s := op.stats
+		rows = append(rows, []string{
+			op.name,
+			fmt.Sprintf("%d", s.Ops),
+			fmt.Sprintf("%.1f", s.OpsPerSec),
+			fmt.Sprintf("%.2f", s.MBPerSec),
+			fmt.Sprintf("%.2f", s.Latency.P50),
+			fmt.Sprintf("%.2f", s.Latency.P99),
+			fmt.Sprintf("%.2f", s.Latency.Max),
+			fmt.Sprintf("%.2f%%", s.ErrorRate*100),
+		})
+	}
+	c.formatter.PrintTable([]string{"Op", "Ops", "Ops/s", "MB/s", "p50 ms", "p99 ms", "Max ms", "Errors"}, rows)
+	for _, sample := range report.Total.ErrorSamples {
+		c.formatter.PrintWarning(sample)
+	}
+	if report.Total.Ops == 0 {
+		c.formatter.PrintWarning("No operations ran")
+	}
+}
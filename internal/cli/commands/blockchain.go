@@ -25,7 +25,7 @@ func NewBlockchainCommand(client *client.Client, formatter *formatter.Formatter,
 		BaseCommand: BaseCommand{
 			name:        "blockchain",
 			description: "Blockchain and smart contract operations",
-			usage:       "blockchain [command] [options]",
+			usage:       "blockchain [wallet|contract|tx|transaction|stats|help] [options]",
 			client:      client,
 			formatter:   formatter,
 		},
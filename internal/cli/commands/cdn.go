@@ -21,8 +21,11 @@ type CDNCommand struct {
 func NewCDNCommand(client *client.Client, formatter *formatter.Formatter, cdnManager *network.CDNManager) *CDNCommand {
 	return &CDNCommand{
 		BaseCommand: BaseCommand{
-			client:    client,
-			formatter: formatter,
+			name:        "cdn",
+			description: "CDN operations",
+			usage:       "cdn [add-node|remove-node|list-nodes|get-node|nearest-node|cache-file|get-cached-file|sync-node|stats|config|update-config] [args...]",
+			client:      client,
+			formatter:   formatter,
 		},
 		cdnManager: cdnManager,
 	}
@@ -447,8 +450,11 @@ type BandwidthCommand struct {
 func NewBandwidthCommand(client *client.Client, formatter *formatter.Formatter, bandwidthManager *network.BandwidthManager) *BandwidthCommand {
 	return &BandwidthCommand{
 		BaseCommand: BaseCommand{
-			client:    client,
-			formatter: formatter,
+			name:        "bandwidth",
+			description: "Bandwidth operations",
+			usage:       "bandwidth [create-policy|update-policy|delete-policy|list-policies|get-policy|get-user-policy|check-bandwidth|record-usage|reset-usage|get-monitor|list-monitors|stats|config|update-config] [args...]",
+			client:      client,
+			formatter:   formatter,
 		},
 		bandwidthManager: bandwidthManager,
 	}
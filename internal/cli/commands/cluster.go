@@ -0,0 +1,140 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// ClusterCommand inspects the Raft group holding the cluster metadata
+type ClusterCommand struct {
+	BaseCommand
+}
+
+// NewClusterCommand creates a new cluster command
+func NewClusterCommand(client *client.Client, formatter *formatter.Formatter) *ClusterCommand {
+	return &ClusterCommand{
+		BaseCommand: BaseCommand{
+			name:        "cluster",
+			description: "Inspect the Raft group holding membership, namespaces, policies, locks and leases",
+			usage:       "cluster [status|members|state]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the cluster command
+func (c *ClusterCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return c.showStatus(ctx)
+	}
+
+	subcommand := strings.ToLower(args[0])
+	switch subcommand {
+	case "status":
+		return c.showStatus(ctx)
+	case "members":
+		return c.showMembers(ctx)
+	case "state":
+		return c.showState(ctx)
+	default:
+		return fmt.Errorf("unknown subcommand: %s", subcommand)
+	}
+}
+
+// showStatus prints the role of the connected node and, on the leader,
+// how far each member has replicated the log
+func (c *ClusterCommand) showStatus(ctx context.Context) error {
+	status, err := c.client.GetClusterStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get cluster status: %w", err)
+	}
+
+	return c.formatter.PrintResult(status, func() {
+		c.formatter.PrintTable([]string{"Field", "Value"}, [][]string{
+			{"Node", status.ID},
+			{"Role", status.Role},
+			{"Term", fmt.Sprintf("%d", status.Term)},
+			{"Leader", orDash(status.Leader)},
+			{"Last Index", fmt.Sprintf("%d", status.LastIndex)},
+			{"Commit Index", fmt.Sprintf("%d", status.CommitIndex)},
+			{"Applied Index", fmt.Sprintf("%d", status.AppliedIndex)},
+			{"Snapshot Index", fmt.Sprintf("%d", status.SnapshotIndex)},
+		})
+		if status.Role != "leader" {
+			return
+		}
+		rows := make([][]string, len(status.Peers))
+		for i, p := range status.Peers {
+			rows[i] = []string{p.ID, p.URL, fmt.Sprintf("%d", p.MatchIndex), formatTime(p.LastContact)}
+		}
+		c.formatter.PrintTable([]string{"Member", "URL", "Match Index", "Last Contact"}, rows)
+	})
+}
+
+// showMembers prints the registered cluster members
+func (c *ClusterCommand) showMembers(ctx context.Context) error {
+	state, err := c.client.GetClusterState(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get cluster state: %w", err)
+	}
+
+	return c.formatter.PrintResult(state.Members, func() {
+		if len(state.Members) == 0 {
+			c.formatter.PrintInfo("No members registered")
+			return
+		}
+		rows := make([][]string, len(state.Members))
+		for i, m := range state.Members {
+			capacity := "-"
+			if m.Capacity > 0 {
+				capacity = c.formatter.FormatBytes(m.Capacity)
+			}
+			rows[i] = []string{m.ID, orDash(m.Addr), capacity, formatTime(m.UpdatedAt)}
+		}
+		c.formatter.PrintTable([]string{"Member", "Address", "Capacity", "Registered"}, rows)
+	})
+}
+
+// showState summarizes the cluster metadata applied on the connected node
+func (c *ClusterCommand) showState(ctx context.Context) error {
+	state, err := c.client.GetClusterState(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get cluster state: %w", err)
+	}
+
+	return c.formatter.PrintResult(state, func() {
+		c.formatter.PrintHeader(fmt.Sprintf("Cluster state at index %d", state.Index))
+		policies := make([]string, 0, len(state.Policies))
+		for ns := range state.Policies {
+			if ns == "" {
+				ns = "(cluster)"
+			}
+			policies = append(policies, ns)
+		}
+		sort.Strings(policies)
+		namespaces := make([]string, len(state.Namespaces))
+		for i, ns := range state.Namespaces {
+			namespaces[i] = ns.Name
+		}
+		c.formatter.PrintTable([]string{"Record", "Count", "Names"}, [][]string{
+			{"Members", fmt.Sprintf("%d", len(state.Members)), ""},
+			{"Namespaces", fmt.Sprintf("%d", len(state.Namespaces)), strings.Join(namespaces, ", ")},
+			{"Policies", fmt.Sprintf("%d", len(state.Policies)), strings.Join(policies, ", ")},
+			{"Locks", fmt.Sprintf("%d", len(state.Locks)), ""},
+			{"Leases", fmt.Sprintf("%d", len(state.Leases)), ""},
+		})
+		if len(state.Leases) > 0 {
+			rows := make([][]string, len(state.Leases))
+			for i, l := range state.Leases {
+				rows[i] = []string{l.Name, l.Holder, fmt.Sprintf("%d", l.Token), formatTime(l.ExpiresAt)}
+			}
+			c.formatter.PrintTable([]string{"Lease", "Holder", "Token", "Expires"}, rows)
+		}
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/Skpow1234/Peervault/internal/cli/operations"
 	"github.com/Skpow1234/Peervault/internal/cli/protocol"
 	"github.com/Skpow1234/Peervault/internal/cli/realtime"
+	"github.com/Skpow1234/Peervault/internal/cli/transfer"
 )
 
 // BaseCommand provides common functionality for all commands
@@ -52,7 +54,7 @@ func NewStoreCommand(client *client.Client, formatter *formatter.Formatter) *Sto
 		BaseCommand: BaseCommand{
 			name:        "store",
 			description: "Store a file in the PeerVault network",
-			usage:       "store <file_path> [options]",
+			usage:       "store <file_path> [--tag a,b] [--meta key=value] [--parallel N] [--encrypt | --key-file FILE]",
 			client:      client,
 			formatter:   formatter,
 		},
@@ -61,6 +63,18 @@ func NewStoreCommand(client *client.Client, formatter *formatter.Formatter) *Sto
 
 // Execute executes the store command
 func (c *StoreCommand) Execute(ctx context.Context, args []string) error {
+	args, workers, err := parseParallelFlag(args)
+	if err != nil {
+		return err
+	}
+	args, encryption, err := parseEncryptionFlags(args)
+	if err != nil {
+		return err
+	}
+	args, tags, meta, err := parseAttributeFlags(args)
+	if err != nil {
+		return err
+	}
 	if len(args) == 0 {
 		return fmt.Errorf("usage: %s", c.usage)
 	}
@@ -71,19 +85,24 @@ func (c *StoreCommand) Execute(ctx context.Context, args []string) error {
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		return fmt.Errorf("file not found: %s", filePath)
 	}
+	key, err := encryption.uploadKey()
+	if err != nil {
+		return err
+	}
 
 	c.formatter.PrintInfo(fmt.Sprintf("Storing file: %s", filePath))
 
-	// Store the file
-	file, err := c.client.StoreFile(ctx, filePath)
+	// Store the file, in parallel chunks if it is large
+	opts, done := newTransferOptions(c.formatter, filepath.Base(filePath), workers)
+	opts.Encryption = key
+	file, err := transfer.New(c.client, opts).Upload(ctx, filePath, tags, meta)
+	done()
 	if err != nil {
 		return err
 	}
 
-	c.formatter.PrintSuccess(fmt.Sprintf("File stored successfully: %s", file.ID))
-	c.formatter.PrintFileInfo(file)
-
-	return nil
+	c.formatter.PrintSuccess(fmt.Sprintf("File stored successfully: %s", file.Key))
+	return c.formatter.PrintFileInfo(file)
 }
 
 // GetCommand handles file retrieval operations
@@ -97,7 +116,7 @@ func NewGetCommand(client *client.Client, formatter *formatter.Formatter) *GetCo
 		BaseCommand: BaseCommand{
 			name:        "get",
 			description: "Retrieve a file from the PeerVault network",
-			usage:       "get <file_id> [output_path]",
+			usage:       "get <file_id> [output_path] [--parallel N] [--key-file FILE]",
 			client:      client,
 			formatter:   formatter,
 		},
@@ -106,6 +125,14 @@ func NewGetCommand(client *client.Client, formatter *formatter.Formatter) *GetCo
 
 // Execute executes the get command
 func (c *GetCommand) Execute(ctx context.Context, args []string) error {
+	args, workers, err := parseParallelFlag(args)
+	if err != nil {
+		return err
+	}
+	args, encryption, err := parseEncryptionFlags(args)
+	if err != nil {
+		return err
+	}
 	if len(args) == 0 {
 		return fmt.Errorf("usage: %s", c.usage)
 	}
@@ -119,27 +146,28 @@ func (c *GetCommand) Execute(ctx context.Context, args []string) error {
 
 	c.formatter.PrintInfo(fmt.Sprintf("Retrieving file: %s", fileID))
 
-	// Get file information
-	file, err := c.client.GetFile(ctx, fileID)
-	if err != nil {
-		return err
-	}
-
-	c.formatter.PrintSuccess("File retrieved successfully")
-	c.formatter.PrintFileInfo(file)
-
-	if outputPath != "" {
-		c.formatter.PrintInfo(fmt.Sprintf("Downloading file to: %s", outputPath))
-
-		err = c.client.DownloadFile(ctx, fileID, outputPath)
+	if outputPath == "" {
+		file, err := c.client.GetFile(ctx, fileID)
 		if err != nil {
-			return fmt.Errorf("failed to download file: %w", err)
+			return err
 		}
+		c.formatter.PrintSuccess("File retrieved successfully")
+		return c.formatter.PrintFileInfo(file)
+	}
+
+	c.formatter.PrintInfo(fmt.Sprintf("Downloading file to: %s", outputPath))
 
-		c.formatter.PrintSuccess(fmt.Sprintf("File downloaded successfully to: %s", outputPath))
+	// Download in parallel chunks if the file is large
+	opts, done := newTransferOptions(c.formatter, filepath.Base(outputPath), workers)
+	opts.DecryptionKey = encryption.downloadKey()
+	file, err := transfer.New(c.client, opts).Download(ctx, fileID, outputPath)
+	done()
+	if err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
 	}
 
-	return nil
+	c.formatter.PrintSuccess(fmt.Sprintf("File downloaded successfully to: %s", outputPath))
+	return c.formatter.PrintFileInfo(file)
 }
 
 // ListCommand handles file listing operations
@@ -153,7 +181,7 @@ func NewListCommand(client *client.Client, formatter *formatter.Formatter) *List
 		BaseCommand: BaseCommand{
 			name:        "list",
 			description: "List files in the PeerVault network",
-			usage:       "list [options]",
+			usage:       "list [--tag a,b] [--meta key=value]",
 			client:      client,
 			formatter:   formatter,
 		},
@@ -162,17 +190,25 @@ func NewListCommand(client *client.Client, formatter *formatter.Formatter) *List
 
 // Execute executes the list command
 func (c *ListCommand) Execute(ctx context.Context, args []string) error {
+	_, tags, meta, err := parseAttributeFlags(args)
+	if err != nil {
+		return err
+	}
+
 	c.formatter.PrintInfo("Retrieving file list...")
 
-	// List files
-	files, err := c.client.ListFiles(ctx)
+	// List files, filtered by tags and metadata when requested
+	var files *client.FileListResponse
+	if len(tags) > 0 || len(meta) > 0 {
+		files, err = c.client.SearchFiles(ctx, tags, meta)
+	} else {
+		files, err = c.client.ListFiles(ctx)
+	}
 	if err != nil {
 		return err
 	}
 
-	c.formatter.PrintFileList(files)
-
-	return nil
+	return c.formatter.PrintFileList(files)
 }
 
 // DeleteCommand handles file deletion operations
@@ -265,9 +301,7 @@ func (c *PeersCommand) listPeers(ctx context.Context) error {
 		return err
 	}
 
-	c.formatter.PrintPeerList(peers)
-
-	return nil
+	return c.formatter.PrintPeerList(peers)
 }
 
 func (c *PeersCommand) addPeer(ctx context.Context, address string) error {
@@ -279,9 +313,7 @@ func (c *PeersCommand) addPeer(ctx context.Context, address string) error {
 	}
 
 	c.formatter.PrintSuccess(fmt.Sprintf("Peer added successfully: %s", peer.ID))
-	c.formatter.PrintPeerInfo(peer)
-
-	return nil
+	return c.formatter.PrintPeerInfo(peer)
 }
 
 func (c *PeersCommand) removePeer(ctx context.Context, peerID string) error {
@@ -308,7 +340,7 @@ func NewConnectCommand(client *client.Client, formatter *formatter.Formatter) *C
 		BaseCommand: BaseCommand{
 			name:        "connect",
 			description: "Connect to a PeerVault node",
-			usage:       "connect <address>",
+			usage:       "connect <host:port|url>",
 			client:      client,
 			formatter:   formatter,
 		},
@@ -325,8 +357,12 @@ func (c *ConnectCommand) Execute(ctx context.Context, args []string) error {
 
 	c.formatter.PrintInfo(fmt.Sprintf("Connecting to: %s", address))
 
-	// Set the server URL
-	c.client.SetServerURL("http://" + address)
+	// Set the server URL; a bare host:port means plain HTTP
+	serverURL := address
+	if !strings.Contains(address, "://") {
+		serverURL = "http://" + address
+	}
+	c.client.SetServerURL(serverURL)
 
 	// Test the connection
 	err := c.client.Connect(ctx)
@@ -365,8 +401,8 @@ func (c *DisconnectCommand) Execute(ctx context.Context, args []string) error {
 	// Disconnect from current server
 	c.client.Disconnect()
 
-	// Reset to default server URL
-	c.client.SetServerURL("http://localhost:8080")
+	// Go back to the server of the active profile or config
+	c.client.ResetServerURL()
 
 	c.formatter.PrintSuccess("Disconnected")
 
@@ -400,9 +436,7 @@ func (c *HealthCommand) Execute(ctx context.Context, args []string) error {
 		return err
 	}
 
-	c.formatter.PrintHealth(health)
-
-	return nil
+	return c.formatter.PrintHealth(health)
 }
 
 // MetricsCommand handles metrics operations
@@ -446,9 +480,7 @@ func (c *MetricsCommand) Execute(ctx context.Context, args []string) error {
 		return err
 	}
 
-	c.formatter.PrintMetrics(metrics)
-
-	return nil
+	return c.formatter.PrintMetrics(metrics)
 }
 
 // StatusCommand handles status operations
@@ -484,11 +516,15 @@ func (c *StatusCommand) Execute(ctx context.Context, args []string) error {
 		return err
 	}
 
-	c.formatter.PrintHealth(health)
-	fmt.Println()
-	c.formatter.PrintMetrics(metrics)
-
-	return nil
+	status := struct {
+		Health  *client.HealthStatus `json:"health"`
+		Metrics *client.Metrics      `json:"metrics"`
+	}{health, metrics}
+	return c.formatter.PrintResult(status, func() {
+		_ = c.formatter.PrintHealth(health)
+		fmt.Println()
+		_ = c.formatter.PrintMetrics(metrics)
+	})
 }
 
 // BlockchainCommand placeholder - implementation moved to blockchain.go
@@ -519,36 +555,6 @@ func (c *DevicesCommand) Execute(ctx context.Context, args []string) error {
 	return nil
 }
 
-// RestoreCommand handles restore operations
-type RestoreCommand struct {
-	BaseCommand
-}
-
-// NewRestoreCommand creates a new restore command
-func NewRestoreCommand(client *client.Client, formatter *formatter.Formatter) *RestoreCommand {
-	return &RestoreCommand{
-		BaseCommand: BaseCommand{
-			name:        "restore",
-			description: "Restore operations",
-			usage:       "restore <backup_id> [options]",
-			client:      client,
-			formatter:   formatter,
-		},
-	}
-}
-
-// Execute executes the restore command
-func (c *RestoreCommand) Execute(ctx context.Context, args []string) error {
-	if len(args) == 0 {
-		return fmt.Errorf("usage: %s", c.usage)
-	}
-
-	backupID := args[0]
-	c.formatter.PrintInfo(fmt.Sprintf("Restore feature not yet implemented for backup: %s", backupID))
-
-	return nil
-}
-
 // ConfigCommand handles configuration operations
 type ConfigCommand struct {
 	BaseCommand
@@ -621,38 +627,6 @@ func (c *SetCommand) Execute(ctx context.Context, args []string) error {
 	return nil
 }
 
-// GetConfigCommand handles getting configuration values
-type GetConfigCommand struct {
-	BaseCommand
-}
-
-// NewGetConfigCommand creates a new get config command
-func NewGetConfigCommand(client *client.Client, formatter *formatter.Formatter) *GetConfigCommand {
-	return &GetConfigCommand{
-		BaseCommand: BaseCommand{
-			name:        "get",
-			description: "Get configuration value",
-			usage:       "get <key>",
-			client:      client,
-			formatter:   formatter,
-		},
-	}
-}
-
-// Execute executes the get config command
-func (c *GetConfigCommand) Execute(ctx context.Context, args []string) error {
-	if len(args) == 0 {
-		return fmt.Errorf("usage: %s", c.usage)
-	}
-
-	key := args[0]
-
-	c.formatter.PrintInfo(fmt.Sprintf("Getting value for: %s", key))
-	c.formatter.PrintInfo("Configuration getting feature not yet implemented")
-
-	return nil
-}
-
 // HelpCommand handles help operations
 type HelpCommand struct {
 	cli *cli.CLI
@@ -1176,7 +1150,7 @@ func NewMonitorCommand(client *client.Client, formatter *formatter.Formatter) *M
 		BaseCommand: BaseCommand{
 			name:        "monitor",
 			description: "Monitor system health and performance",
-			usage:       "monitor [start|stop|status|alerts|dashboard] [options]",
+			usage:       "monitor [start|stop|status|alerts|dashboard|topology] [options]",
 			client:      client,
 			formatter:   formatter,
 		},
@@ -1199,9 +1173,11 @@ func (c *MonitorCommand) Execute(ctx context.Context, args []string) error {
 	case "status":
 		return c.showStatus()
 	case "alerts":
-		return c.showAlerts()
+		return c.handleAlerts(ctx, args[1:])
 	case "dashboard":
 		return c.showDashboard()
+	case "topology":
+		return c.showTopology(ctx)
 	default:
 		return fmt.Errorf("unknown subcommand: %s", subcommand)
 	}
@@ -1279,7 +1255,7 @@ func (c *MonitorCommand) showStatus() error {
 	return nil
 }
 
-// showAlerts shows all alerts
+// showAlerts shows the alerts evaluated locally by monitor start
 func (c *MonitorCommand) showAlerts() error {
 	alerts := c.monitoringManager.GetAlerts()
 
@@ -1336,5 +1312,78 @@ func (c *MonitorCommand) showDashboard() error {
 		fmt.Printf("  %s %s: %s\n", emoji, service, status)
 	}
 
+	// Only servers running on a PeerVault node measure peer latency
+	topology, err := c.client.GetPeerTopology(ctx)
+	if err != nil || len(topology.Nodes) < 2 {
+		return nil
+	}
+	fmt.Println("\nPeer Latency (ms):")
+	c.printLatencyMatrix(topology)
+
 	return nil
 }
+
+// showTopology shows the latency matrix and the measured links
+func (c *MonitorCommand) showTopology(ctx context.Context) error {
+	topology, err := c.client.GetPeerTopology(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get peer topology: %w", err)
+	}
+
+	return c.formatter.PrintResult(topology, func() {
+		if len(topology.Links) == 0 {
+			c.formatter.PrintInfo("No peer latency measured yet")
+			return
+		}
+		c.printLatencyMatrix(topology)
+
+		rows := make([][]string, 0, len(topology.Links))
+		for _, l := range topology.Links {
+			rows = append(rows, []string{
+				nodeLabel(l.From),
+				nodeLabel(l.To),
+				fmt.Sprintf("%.2f", l.RTTMs),
+				fmt.Sprintf("%.2f", l.MinRTTMs),
+				fmt.Sprintf("%.2f", l.JitterMs),
+				fmt.Sprintf("%.0f%%", l.Loss*100),
+				fmt.Sprintf("%d", l.Samples),
+			})
+		}
+		fmt.Println()
+		c.formatter.PrintTable([]string{"From", "To", "RTT (ms)", "Min (ms)", "Jitter (ms)", "Loss", "Samples"}, rows)
+	})
+}
+
+// printLatencyMatrix prints the round trip times between all nodes, rows
+// being the source; the server's own node is marked with *
+func (c *MonitorCommand) printLatencyMatrix(topology *client.PeerTopology) {
+	headers := []string{""}
+	for _, n := range topology.Nodes {
+		headers = append(headers, nodeLabel(n.ID))
+	}
+	rows := make([][]string, len(topology.Nodes))
+	for i, n := range topology.Nodes {
+		label := nodeLabel(n.ID)
+		if n.Self {
+			label += " *"
+		}
+		rows[i] = []string{label}
+		for j := range topology.Nodes {
+			cell := "-"
+			if i < len(topology.Matrix) && j < len(topology.Matrix[i]) && topology.Matrix[i][j] != nil {
+				cell = fmt.Sprintf("%.2f", *topology.Matrix[i][j])
+			}
+			rows[i] = append(rows[i], cell)
+		}
+	}
+	c.formatter.PrintTable(headers, rows)
+}
+
+// nodeLabel shortens node IDs to fit a table column; addresses of nodes
+// whose ID is not known yet are kept whole
+func nodeLabel(id string) string {
+	if len(id) > 12 && !strings.Contains(id, ":") {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/Skpow1234/Peervault/internal/cli"
+	"github.com/Skpow1234/Peervault/internal/cli/completion"
+)
+
+// CompletionCommand generates shell completion scripts
+type CompletionCommand struct {
+	BaseCommand
+	cli         *cli.CLI
+	program     string
+	globalFlags []completion.Flag
+}
+
+// NewCompletionCommand creates a new completion command. Subcommands and
+// flags come from the usage of each command registered in cliApp.
+func NewCompletionCommand(cliApp *cli.CLI, program string, globalFlags []completion.Flag) *CompletionCommand {
+	return &CompletionCommand{
+		BaseCommand: BaseCommand{
+			name:        "completion",
+			description: "Generate a shell completion script",
+			usage:       "completion bash|zsh|fish|powershell",
+		},
+		cli:         cliApp,
+		program:     program,
+		globalFlags: globalFlags,
+	}
+}
+
+// Execute writes the completion script for the requested shell to stdout
+func (c *CompletionCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", c.usage)
+	}
+	shell := args[0]
+	if !contains(completion.Shells, shell) {
+		return fmt.Errorf("usage: %s (unsupported shell: %s)", c.usage, shell)
+	}
+	return completion.Generate(os.Stdout, shell, c.program, c.globalFlags, c.specs())
+}
+
+// specs describes every registered name, aliases included
+func (c *CompletionCommand) specs() []completion.Command {
+	names := c.cli.GetCommandNames()
+	sort.Strings(names)
+
+	specs := make([]completion.Command, 0, len(names))
+	for _, name := range names {
+		cmd, _ := c.cli.GetCommand(name)
+		spec := completion.FromUsage(name, cmd.Description(), cmd.Usage())
+		if name == "help" {
+			// help takes a command name
+			spec.Subcommands = names
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
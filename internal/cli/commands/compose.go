@@ -0,0 +1,135 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// maxComposeParts is the most files the server joins in one request
+const maxComposeParts = 32
+
+// ComposeCommand joins stored files into one, and uploads large files as
+// parts in parallel
+type ComposeCommand struct {
+	BaseCommand
+}
+
+// NewComposeCommand creates a new compose command
+func NewComposeCommand(client *client.Client, formatter *formatter.Formatter) *ComposeCommand {
+	return &ComposeCommand{
+		BaseCommand: BaseCommand{
+			name:        "compose",
+			description: "Join stored files into one, or upload a file as parallel parts",
+			usage:       "compose <to> <source> [source...] [--delete-sources] [--overwrite] | compose upload <file> <key> [--parts N]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the compose command
+func (c *ComposeCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: %s", c.usage)
+	}
+	if strings.ToLower(args[0]) == "upload" {
+		return c.upload(ctx, args[1:])
+	}
+
+	req := &client.ObjectCompose{To: args[0]}
+	for _, arg := range args[1:] {
+		switch arg {
+		case "--delete-sources":
+			req.DeleteSources = true
+		case "--overwrite":
+			req.Overwrite = true
+		default:
+			req.Sources = append(req.Sources, arg)
+		}
+	}
+	if len(req.Sources) == 0 {
+		return fmt.Errorf("usage: %s", c.usage)
+	}
+	file, err := c.client.ComposeObject(ctx, req)
+	if err != nil {
+		return fmt.Errorf("compose failed: %w", err)
+	}
+	c.formatter.PrintSuccess(fmt.Sprintf("Composed %d file(s) into %s", len(req.Sources), file.Key))
+	return c.formatter.PrintFileInfo(file)
+}
+
+// upload stores a local file as parts uploaded concurrently under
+// <key>.part-NNN, then joins them on the server and deletes the parts
+func (c *ComposeCommand) upload(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: compose upload <file> <key> [--parts N]")
+	}
+	local, key := args[0], args[1]
+	parts := 4
+	if len(args) >= 4 && args[2] == "--parts" {
+		n, err := strconv.Atoi(args[3])
+		if err != nil || n < 1 || n > maxComposeParts {
+			return fmt.Errorf("--parts must be 1 to %d", maxComposeParts)
+		}
+		parts = n
+	}
+
+	f, err := os.Open(local)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	size := info.Size()
+	if size < int64(parts) {
+		parts = max(int(size), 1)
+	}
+	partSize := (size + int64(parts) - 1) / int64(parts)
+	if partSize > 0 {
+		// Rounding up can leave the last parts empty
+		parts = int((size + partSize - 1) / partSize)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sources := make([]string, parts)
+	errs := make([]error, parts)
+	var wg sync.WaitGroup
+	for i := range parts {
+		sources[i] = fmt.Sprintf("%s.part-%03d", key, i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			section := io.NewSectionReader(f, int64(i)*partSize, partSize)
+			if _, err := c.client.UploadDataAt(ctx, sources[i], filepath.Base(sources[i]), "", section, nil, nil); err != nil {
+				errs[i] = fmt.Errorf("part %d: %w", i, err)
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return fmt.Errorf("upload failed: %w", err)
+		}
+	}
+
+	file, err := c.client.ComposeObject(ctx, &client.ObjectCompose{Sources: sources, To: key, Overwrite: true, DeleteSources: true})
+	if err != nil {
+		return fmt.Errorf("compose failed: %w", err)
+	}
+	c.formatter.PrintSuccess(fmt.Sprintf("Stored %s as %s from %d part(s) (%s)", local, file.Key, parts, c.formatter.FormatBytes(file.Size)))
+	return nil
+}
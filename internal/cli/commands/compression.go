@@ -21,8 +21,11 @@ type CompressionCommand struct {
 func NewCompressionCommand(client *client.Client, formatter *formatter.Formatter, compressionManager *files.CompressionManager) *CompressionCommand {
 	return &CompressionCommand{
 		BaseCommand: BaseCommand{
-			client:    client,
-			formatter: formatter,
+			name:        "compress",
+			description: "File compression operations",
+			usage:       "compress [file|decompress|settings|update-settings|stats|reset-stats] [args...]",
+			client:      client,
+			formatter:   formatter,
 		},
 		compressionManager: compressionManager,
 	}
@@ -228,8 +231,11 @@ type DeduplicationCommand struct {
 func NewDeduplicationCommand(client *client.Client, formatter *formatter.Formatter, deduplicationManager *files.DeduplicationManager) *DeduplicationCommand {
 	return &DeduplicationCommand{
 		BaseCommand: BaseCommand{
-			client:    client,
-			formatter: formatter,
+			name:        "dedup",
+			description: "File deduplication operations",
+			usage:       "dedup [file|reconstruct|chunk|list-chunks|remove-file|cleanup|stats|reset-stats] [args...]",
+			client:      client,
+			formatter:   formatter,
 		},
 		deduplicationManager: deduplicationManager,
 	}
@@ -0,0 +1,83 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// CopyCommand copies or moves a file to another key on the server
+type CopyCommand struct {
+	BaseCommand
+	move bool
+}
+
+// NewCopyCommand creates a new cp command
+func NewCopyCommand(client *client.Client, formatter *formatter.Formatter) *CopyCommand {
+	return &CopyCommand{
+		BaseCommand: BaseCommand{
+			name:        "cp",
+			description: "Copy a file to another key or tenant on the server",
+			usage:       "cp <from> <to> [--tenant <tenant>] [--overwrite] [key=value...]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// NewMoveCommand creates a new mv command
+func NewMoveCommand(client *client.Client, formatter *formatter.Formatter) *CopyCommand {
+	return &CopyCommand{
+		BaseCommand: BaseCommand{
+			name:        "mv",
+			description: "Move or rename a file, or hand it to another tenant, on the server",
+			usage:       "mv <from> <to> [--tenant <tenant>] [--overwrite] [key=value...]",
+			client:      client,
+			formatter:   formatter,
+		},
+		move: true,
+	}
+}
+
+// Execute executes the copy command
+func (c *CopyCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: %s", c.usage)
+	}
+
+	req := &client.ObjectCopy{From: args[0], To: args[1]}
+	for i := 2; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--overwrite":
+			req.Overwrite = true
+		case arg == "--tenant":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--tenant needs a value")
+			}
+			i++
+			req.Tenant = args[i]
+		case strings.Contains(arg, "="):
+			key, value, _ := strings.Cut(arg, "=")
+			if req.Metadata == nil {
+				req.Metadata = make(map[string]string)
+			}
+			req.Metadata[key] = value
+		default:
+			return fmt.Errorf("unknown argument %q; usage: %s", arg, c.usage)
+		}
+	}
+
+	transfer, verb := c.client.CopyObject, "Copied"
+	if c.move {
+		transfer, verb = c.client.MoveObject, "Moved"
+	}
+	file, err := transfer(ctx, req)
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", c.name, err)
+	}
+	c.formatter.PrintSuccess(fmt.Sprintf("%s %s to %s", verb, req.From, file.Key))
+	return c.formatter.PrintFileInfo(file)
+}
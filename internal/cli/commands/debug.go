@@ -0,0 +1,175 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+	"github.com/Skpow1234/Peervault/internal/diagnostics"
+)
+
+// defaultDiagnosticsPort is the port of diagnostics.DefaultAddr
+const defaultDiagnosticsPort = "6060"
+
+// DebugCommand fetches profiles and runtime statistics from the
+// diagnostics endpoint of a server
+type DebugCommand struct {
+	BaseCommand
+}
+
+// NewDebugCommand creates a new debug command
+func NewDebugCommand(client *client.Client, formatter *formatter.Formatter) *DebugCommand {
+	return &DebugCommand{
+		BaseCommand: BaseCommand{
+			name:        "debug",
+			description: "Fetch profiles and runtime stats from a server's diagnostics endpoint",
+			usage:       "debug [profile <" + strings.Join(diagnostics.Profiles, "|") + "> [duration] [--out file]|runtime] [--addr url] [--token token]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the debug command
+func (c *DebugCommand) Execute(ctx context.Context, args []string) error {
+	var addr, token, out string
+	flags := flag.NewFlagSet("debug", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.StringVar(&addr, "addr", "", "Diagnostics URL (default: the server host on port "+defaultDiagnosticsPort+")")
+	flags.StringVar(&token, "token", "", "Diagnostics token (default $"+diagnostics.TokenEnv+", then the connection token)")
+	flags.StringVar(&out, "out", "", "File to save the profile to")
+	// Flags may come before, between or after the arguments
+	var positional []string
+	for rest := args; ; rest = flags.Args()[1:] {
+		if err := flags.Parse(rest); err != nil {
+			return fmt.Errorf("usage: %s: %v", c.usage, err)
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+	}
+	if len(positional) == 0 {
+		return fmt.Errorf("usage: %s", c.usage)
+	}
+
+	baseURL, err := c.diagnosticsURL(addr)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		token = os.Getenv(diagnostics.TokenEnv)
+	}
+	if token == "" {
+		token = c.client.AuthToken()
+	}
+	hc := &http.Client{Transport: c.client.Transport()}
+
+	switch strings.ToLower(positional[0]) {
+	case "profile":
+		if len(positional) < 2 || len(positional) > 3 {
+			return fmt.Errorf("usage: debug profile <kind> [duration] [--out file]")
+		}
+		var d time.Duration
+		if len(positional) == 3 {
+			if d, err = time.ParseDuration(positional[2]); err != nil {
+				return fmt.Errorf("usage: invalid duration %q", positional[2])
+			}
+		}
+		return c.profile(ctx, hc, baseURL, token, strings.ToLower(positional[1]), d, out)
+	case "runtime":
+		return c.runtime(ctx, hc, baseURL, token)
+	default:
+		return fmt.Errorf("unknown subcommand: %s", positional[0])
+	}
+}
+
+// diagnosticsURL returns addr, or the URL of the connected server on the
+// default diagnostics port
+func (c *DebugCommand) diagnosticsURL(addr string) (string, error) {
+	if addr != "" {
+		if !strings.Contains(addr, "://") {
+			addr = "http://" + addr
+		}
+		return addr, nil
+	}
+	u, err := url.Parse(c.client.ServerURL())
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("cannot derive the diagnostics address from %q; pass --addr", c.client.ServerURL())
+	}
+	return u.Scheme + "://" + net.JoinHostPort(u.Hostname(), defaultDiagnosticsPort), nil
+}
+
+// profile saves a profile, by default to <kind>-<time>.pprof (or .trace)
+func (c *DebugCommand) profile(ctx context.Context, hc *http.Client, baseURL, token, kind string, d time.Duration, out string) error {
+	if kind == "cpu" && d == 0 {
+		d = 30 * time.Second
+	}
+	if _, err := diagnostics.ProfilePath(kind, d); err != nil {
+		return fmt.Errorf("usage: %v", err)
+	}
+	if out == "" {
+		ext := ".pprof"
+		if kind == "trace" {
+			ext = ".trace"
+		}
+		out = kind + "-" + time.Now().Format("20060102-150405") + ext
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", out, err)
+	}
+	if d > 0 {
+		c.formatter.PrintInfo(fmt.Sprintf("Recording %s profile for %s...", kind, d))
+	}
+	n, err := diagnostics.Fetch(ctx, hc, baseURL, token, kind, d, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(out)
+		return fmt.Errorf("failed to fetch %s profile: %w", kind, err)
+	}
+
+	c.formatter.PrintSuccess(fmt.Sprintf("Saved %s profile to %s (%d bytes)", kind, out, n))
+	if kind == "trace" {
+		c.formatter.PrintInfo("Inspect it with: go tool trace " + out)
+	} else {
+		c.formatter.PrintInfo("Inspect it with: go tool pprof -http=: " + out)
+	}
+	return nil
+}
+
+// runtime prints goroutine, memory and GC statistics
+func (c *DebugCommand) runtime(ctx context.Context, hc *http.Client, baseURL, token string) error {
+	stats, err := diagnostics.FetchRuntime(ctx, hc, baseURL, token)
+	if err != nil {
+		return fmt.Errorf("failed to fetch runtime stats: %w", err)
+	}
+	return c.formatter.PrintResult(stats, func() {
+		c.formatter.PrintTable([]string{"Field", "Value"}, [][]string{
+			{"Go version", stats.GoVersion},
+			{"PID", fmt.Sprintf("%d", stats.PID)},
+			{"Uptime", time.Duration(stats.UptimeSeconds * float64(time.Second)).Round(time.Second).String()},
+			{"CPUs / GOMAXPROCS", fmt.Sprintf("%d / %d", stats.NumCPU, stats.GOMAXPROCS)},
+			{"Goroutines", fmt.Sprintf("%d", stats.Goroutines)},
+			{"Heap in use", c.formatter.FormatBytes(int64(stats.Memory.HeapInuse))},
+			{"Heap objects", fmt.Sprintf("%d", stats.Memory.HeapObjects)},
+			{"Memory from OS", c.formatter.FormatBytes(int64(stats.Memory.Sys))},
+			{"GC cycles", fmt.Sprintf("%d", stats.GC.NumGC)},
+			{"Next GC at", c.formatter.FormatBytes(int64(stats.GC.NextGC))},
+			{"GC pause total", fmt.Sprintf("%.2f ms", stats.GC.PauseTotalMs)},
+			{"GC CPU share", fmt.Sprintf("%.2f%%", stats.GC.CPUFraction*100)},
+		})
+	})
+}
@@ -0,0 +1,133 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// decommissionPollInterval is how often decommission wait checks progress
+const decommissionPollInterval = 2 * time.Second
+
+// DecommissionCommand takes the server out of the cluster once its files
+// are on the other nodes
+type DecommissionCommand struct {
+	BaseCommand
+}
+
+// NewDecommissionCommand creates a new decommission command
+func NewDecommissionCommand(client *client.Client, formatter *formatter.Formatter) *DecommissionCommand {
+	return &DecommissionCommand{
+		BaseCommand: BaseCommand{
+			name:        "decommission",
+			description: "Move the server's files to the other nodes and take it out of the cluster",
+			usage:       "decommission [status|start [--wait]|wait|abort]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the decommission command
+func (c *DecommissionCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return c.showStatus(ctx)
+	}
+
+	subcommand := strings.ToLower(args[0])
+	switch subcommand {
+	case "status":
+		return c.showStatus(ctx)
+	case "start":
+		return c.start(ctx, len(args) > 1 && args[1] == "--wait")
+	case "wait":
+		return c.wait(ctx)
+	case "abort":
+		return c.abort(ctx)
+	default:
+		return fmt.Errorf("unknown subcommand: %s", subcommand)
+	}
+}
+
+func (c *DecommissionCommand) showStatus(ctx context.Context) error {
+	status, err := c.client.GetDecommission(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get decommission status: %w", err)
+	}
+	return c.formatter.PrintResult(status, func() { c.printStatus(status) })
+}
+
+func (c *DecommissionCommand) start(ctx context.Context, wait bool) error {
+	status, err := c.client.StartDecommission(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start decommission: %w", err)
+	}
+	if wait {
+		return c.wait(ctx)
+	}
+	return c.formatter.PrintResult(status, func() {
+		c.formatter.PrintSuccess("Decommission started; the node is off the ring and moving its files")
+		c.formatter.PrintInfo("Follow the progress with: decommission wait")
+	})
+}
+
+// wait reports progress until the node has left the cluster or stopped
+// leaving it
+func (c *DecommissionCommand) wait(ctx context.Context) error {
+	ticker := time.NewTicker(decommissionPollInterval)
+	defer ticker.Stop()
+	for {
+		status, err := c.client.GetDecommission(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get decommission status: %w", err)
+		}
+		if status.Phase != "evacuating" {
+			return c.formatter.PrintResult(status, func() { c.printStatus(status) })
+		}
+		c.formatter.PrintInfo(fmt.Sprintf("%d of %d files moved, %d pending, %s copied",
+			status.Evacuated, status.Files, status.Pending, c.formatter.FormatBytes(status.Bytes)))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *DecommissionCommand) abort(ctx context.Context) error {
+	status, err := c.client.AbortDecommission(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to abort decommission: %w", err)
+	}
+	return c.formatter.PrintResult(status, func() {
+		c.formatter.PrintSuccess(fmt.Sprintf("Decommission aborted after moving %d of %d files; the node is back on the ring", status.Evacuated, status.Files))
+	})
+}
+
+func (c *DecommissionCommand) printStatus(status *client.DecommissionStatus) {
+	switch status.Phase {
+	case "decommissioned":
+		c.formatter.PrintSuccess("The node has left the cluster and can be stopped")
+	case "evacuating":
+		c.formatter.PrintInfo("The node is moving its files to the other nodes")
+	}
+	rows := [][]string{
+		{"Phase", status.Phase},
+		{"Files", fmt.Sprintf("%d", status.Files)},
+		{"Moved", fmt.Sprintf("%d", status.Evacuated)},
+		{"Pending", fmt.Sprintf("%d", status.Pending)},
+		{"Copies", fmt.Sprintf("%d", status.Copies)},
+		{"Copied", c.formatter.FormatBytes(status.Bytes)},
+		{"Started", formatTime(status.StartedAt)},
+		{"Finished", formatTime(status.FinishedAt)},
+	}
+	if status.LastError != "" {
+		rows = append(rows, []string{"Last Error", status.LastError})
+	}
+	c.formatter.PrintTable([]string{"Field", "Value"}, rows)
+}
@@ -0,0 +1,171 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// DirectoryCommand browses and reorganizes the key space as folders
+type DirectoryCommand struct {
+	BaseCommand
+}
+
+// NewDirectoryCommand creates a new directory command
+func NewDirectoryCommand(client *client.Client, formatter *formatter.Formatter) *DirectoryCommand {
+	return &DirectoryCommand{
+		BaseCommand: BaseCommand{
+			name:        "dir",
+			description: "List, create, copy, move and delete folders of files",
+			usage:       "dir [ls [path] [-r]|tree [path]|mkdir <path>|put <file> <path>|cp <from> <to> [--overwrite]|mv <from> <to> [--overwrite]|rm <path> [-r]]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the directory command
+func (c *DirectoryCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return c.list(ctx, "", false)
+	}
+
+	subcommand := strings.ToLower(args[0])
+	switch subcommand {
+	case "ls", "list":
+		path, rest := optionalPath(args[1:])
+		return c.list(ctx, path, contains(rest, "-r") || contains(rest, "--recursive"))
+	case "tree":
+		path, _ := optionalPath(args[1:])
+		return c.list(ctx, path, true)
+	case "mkdir":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: dir mkdir <path>")
+		}
+		return c.mkdir(ctx, args[1])
+	case "put", "upload":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: dir put <file> <path>")
+		}
+		return c.put(ctx, args[1], args[2])
+	case "cp", "copy":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: dir cp <from> <to> [--overwrite]")
+		}
+		report, err := c.client.CopyPath(ctx, args[1], args[2], contains(args[3:], "--overwrite"))
+		if err != nil {
+			return fmt.Errorf("copy failed: %w", err)
+		}
+		return c.printReport(report, "Copied", contains(args[3:], "--overwrite"))
+	case "mv", "move", "rename":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: dir mv <from> <to> [--overwrite]")
+		}
+		report, err := c.client.MovePath(ctx, args[1], args[2], contains(args[3:], "--overwrite"))
+		if err != nil {
+			return fmt.Errorf("move failed: %w", err)
+		}
+		return c.printReport(report, "Moved", contains(args[3:], "--overwrite"))
+	case "rm", "delete":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: dir rm <path> [-r]")
+		}
+		recursive := contains(args[2:], "-r") || contains(args[2:], "--recursive")
+		report, err := c.client.DeleteDirectory(ctx, args[1], recursive)
+		if err != nil {
+			return fmt.Errorf("delete failed: %w", err)
+		}
+		return c.printReport(report, "Deleted", true)
+	default:
+		// dir <path> lists that folder
+		return c.list(ctx, args[0], contains(args[1:], "-r"))
+	}
+}
+
+// optionalPath splits an optional leading path from the flags after it
+func optionalPath(args []string) (string, []string) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return args[0], args[1:]
+	}
+	return "", args
+}
+
+// list prints the folders and files of a folder
+func (c *DirectoryCommand) list(ctx context.Context, path string, recursive bool) error {
+	listing, err := c.client.ListDirectory(ctx, path, recursive)
+	if err != nil {
+		return fmt.Errorf("failed to list directory: %w", err)
+	}
+	return c.formatter.PrintResult(listing, func() {
+		if len(listing.Directories) == 0 && len(listing.Files) == 0 {
+			c.formatter.PrintInfo(fmt.Sprintf("%s is empty", orDash(listing.Path)))
+			return
+		}
+
+		rows := make([][]string, 0, len(listing.Directories)+len(listing.Files))
+		for _, d := range listing.Directories {
+			rows = append(rows, []string{d.Path, "dir", fmt.Sprintf("%d file(s)", d.Files), c.formatter.FormatBytes(d.Size), "-"})
+		}
+		for _, f := range listing.Files {
+			rows = append(rows, []string{f.Key, "file", orDash(f.ContentType), c.formatter.FormatBytes(f.Size), formatTime(f.ModTime)})
+		}
+		c.formatter.PrintTable([]string{"Path", "Type", "Content", "Size", "Modified"}, rows)
+		c.formatter.PrintInfo(fmt.Sprintf("%d file(s), %s below %s",
+			listing.TotalFiles, c.formatter.FormatBytes(listing.TotalSize), orDash(listing.Path)))
+	})
+}
+
+// mkdir creates a folder that is kept while empty
+func (c *DirectoryCommand) mkdir(ctx context.Context, path string) error {
+	dir, err := c.client.MakeDirectory(ctx, path)
+	if err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	c.formatter.PrintSuccess(fmt.Sprintf("Created %s", dir.Path))
+	return nil
+}
+
+// put uploads a local file to a path; a path ending in "/" keeps the name
+func (c *DirectoryCommand) put(ctx context.Context, local, path string) error {
+	f, err := os.Open(local)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := c.client.UploadDataAt(ctx, path, filepath.Base(local), "", f, nil, nil)
+	if err != nil {
+		return err
+	}
+	c.formatter.PrintSuccess(fmt.Sprintf("Stored %s as %s (%s)", local, info.Key, c.formatter.FormatBytes(info.Size)))
+	return nil
+}
+
+// printReport prints the outcome of a recursive operation
+func (c *DirectoryCommand) printReport(report *client.DirectoryReport, verb string, overwrite bool) error {
+	err := c.formatter.PrintResult(report, func() {
+		target := report.From
+		if report.To != "" {
+			target += " -> " + report.To
+		}
+		c.formatter.PrintSuccess(fmt.Sprintf("%s %d file(s), %s: %s", verb, report.Files, c.formatter.FormatBytes(report.Bytes), target))
+	})
+	if err != nil {
+		return err
+	}
+	for _, msg := range report.Errors {
+		c.formatter.PrintWarning(msg)
+	}
+	if report.Skipped > 0 && !overwrite {
+		c.formatter.PrintInfo(fmt.Sprintf("%d existing file(s) were kept; re-run with --overwrite to replace them", report.Skipped))
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d file(s) failed", report.Failed)
+	}
+	return nil
+}
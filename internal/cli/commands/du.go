@@ -0,0 +1,96 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// DuCommand shows the storage used under a prefix or by each tenant
+type DuCommand struct {
+	BaseCommand
+}
+
+// NewDuCommand creates a new du command
+func NewDuCommand(client *client.Client, formatter *formatter.Formatter) *DuCommand {
+	return &DuCommand{
+		BaseCommand: BaseCommand{
+			name:        "du",
+			description: "Show the storage used under a prefix or by each tenant",
+			usage:       "du [prefix] [--depth N] | du --tenants",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the du command
+func (c *DuCommand) Execute(ctx context.Context, args []string) error {
+	var prefix string
+	depth, tenants := 1, false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--depth", "-d":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a value", args[i])
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid %s value: %s", args[i], args[i+1])
+			}
+			depth = n
+			i++
+		case "--tenants":
+			tenants = true
+		default:
+			if prefix != "" {
+				return fmt.Errorf("usage: %s", c.usage)
+			}
+			prefix = args[i]
+		}
+	}
+
+	if tenants {
+		usage, err := c.client.GetTenantUsage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get tenant usage: %w", err)
+		}
+		return c.print("Tenant", usage, func(u client.Usage) string { return u.Tenant })
+	}
+
+	usage, err := c.client.GetUsage(ctx, prefix, depth)
+	if err != nil {
+		return fmt.Errorf("failed to get usage: %w", err)
+	}
+	return c.print("Prefix", usage, func(u client.Usage) string {
+		if u.Prefix == "" {
+			return "/"
+		}
+		return u.Prefix
+	})
+}
+
+// print lists usage in a table whose first column, named column, is the
+// name of each entry
+func (c *DuCommand) print(column string, usage []client.Usage, name func(client.Usage) string) error {
+	return c.formatter.PrintResult(usage, func() {
+		if len(usage) == 0 {
+			c.formatter.PrintInfo("No files")
+			return
+		}
+		rows := make([][]string, len(usage))
+		for i, u := range usage {
+			rows[i] = []string{
+				name(u),
+				fmt.Sprintf("%d", u.Objects),
+				c.formatter.FormatBytes(u.Bytes),
+				fmt.Sprintf("%d", u.Versions),
+				c.formatter.FormatBytes(u.VersionBytes),
+			}
+		}
+		c.formatter.PrintTable([]string{column, "Files", "Size", "Versions", "Version Size"}, rows)
+	})
+}
@@ -23,7 +23,7 @@ func NewEdgeCommand(client *client.Client, formatter *formatter.Formatter, edgeM
 		BaseCommand: BaseCommand{
 			name:        "edge",
 			description: "Edge computing operations",
-			usage:       "edge [command] [options]",
+			usage:       "edge [add-node|remove-node|list-nodes|get-node|update-status|update-metrics|create-task|get-task|list-tasks|get-tasks-by-node|update-task-status|create-workload|get-workload|list-workloads|schedule-workload|stats] [options]",
 			client:      client,
 			formatter:   formatter,
 		},
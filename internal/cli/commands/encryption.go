@@ -0,0 +1,124 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/cli/terminal"
+	"github.com/Skpow1234/Peervault/pkg/e2e"
+)
+
+// PassphraseEnv names the environment variable holding the passphrase of
+// files encrypted on the client, read instead of prompting for it
+const PassphraseEnv = "PEERVAULT_PASSPHRASE"
+
+// encryptionFlags are the options of client-side encryption
+type encryptionFlags struct {
+	encrypt bool
+	keyFile string
+}
+
+// parseEncryptionFlags removes --encrypt and --key-file FILE from args
+func parseEncryptionFlags(args []string) ([]string, encryptionFlags, error) {
+	var rest []string
+	var flags encryptionFlags
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--encrypt":
+			flags.encrypt = true
+		case "--key-file":
+			if i+1 >= len(args) {
+				return nil, flags, fmt.Errorf("--key-file requires a path")
+			}
+			i++
+			flags.keyFile = args[i]
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	return rest, flags, nil
+}
+
+// uploadKey returns the key to encrypt an upload with, or nil when it is
+// stored in plaintext
+func (f encryptionFlags) uploadKey() (*e2e.Key, error) {
+	if f.keyFile != "" {
+		return readKeyFile(f.keyFile)
+	}
+	if !f.encrypt {
+		return nil, nil
+	}
+	return readPassphrase()
+}
+
+// downloadKey returns a function giving the key for an encrypted download,
+// prompting for the passphrase when the file was encrypted with one
+func (f encryptionFlags) downloadKey() func(scheme, keyID string) (*e2e.Key, error) {
+	return func(scheme, keyID string) (*e2e.Key, error) {
+		if f.keyFile != "" {
+			return readKeyFile(f.keyFile)
+		}
+		if scheme == e2e.SchemeKey {
+			return nil, fmt.Errorf("the file is encrypted with key %s, pass it with --key-file", keyID)
+		}
+		return readPassphrase()
+	}
+}
+
+// readKeyFile reads a key in base64 or hex from path
+func readKeyFile(path string) (*e2e.Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	return e2e.ParseKey(string(data))
+}
+
+// readPassphrase takes the passphrase from PassphraseEnv, or else from the
+// first line of stdin, prompting for it without echo on a terminal
+func readPassphrase() (*e2e.Key, error) {
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return e2e.NewPassphrase(passphrase)
+	}
+
+	interactive := terminal.IsTerminalFile(os.Stdin)
+	if interactive {
+		fmt.Fprint(os.Stderr, "Passphrase: ")
+		if restore := disableEcho(); restore != nil {
+			defer func() {
+				restore()
+				fmt.Fprintln(os.Stderr)
+			}()
+		}
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	passphrase := strings.TrimRight(line, "\r\n")
+	if passphrase == "" {
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		return nil, fmt.Errorf("no passphrase given")
+	}
+	return e2e.NewPassphrase(passphrase)
+}
+
+// disableEcho stops the terminal on stdin from echoing input and returns
+// the function restoring it, or nil where that is not supported
+func disableEcho() func() {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+	if stty("-echo") != nil {
+		return nil
+	}
+	return func() { _ = stty("echo") }
+}
@@ -0,0 +1,83 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// ExportsCommand lists the CSV and Parquet exports of the node and runs
+// them on demand
+type ExportsCommand struct {
+	BaseCommand
+}
+
+// NewExportsCommand creates a new exports command
+func NewExportsCommand(client *client.Client, formatter *formatter.Formatter) *ExportsCommand {
+	return &ExportsCommand{
+		BaseCommand: BaseCommand{
+			name:        "exports",
+			description: "List metadata, access and rollup exports, or run one now",
+			usage:       "exports [list|run <name>]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the exports command
+func (c *ExportsCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return c.listExports(ctx)
+	}
+
+	subcommand := strings.ToLower(args[0])
+	switch subcommand {
+	case "list", "ls":
+		return c.listExports(ctx)
+	case "run":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: exports run <name>")
+		}
+		return c.runExport(ctx, args[1])
+	default:
+		return fmt.Errorf("unknown subcommand: %s", subcommand)
+	}
+}
+
+// listExports prints the exports of the server with their last run
+func (c *ExportsCommand) listExports(ctx context.Context) error {
+	list, err := c.client.ListExports(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list exports: %w", err)
+	}
+	return c.formatter.PrintResult(list, func() {
+		if len(list.Exports) == 0 {
+			c.formatter.PrintInfo("No exports configured")
+			return
+		}
+		rows := make([][]string, len(list.Exports))
+		for i, e := range list.Exports {
+			rows[i] = []string{
+				e.Name, e.Dataset, e.Format, orDash(e.Schedule), e.Destination,
+				formatTime(e.LastRun), orDash(e.LastKey), strconv.Itoa(e.LastRows), orDash(e.LastError),
+			}
+		}
+		c.formatter.PrintTable([]string{"Export", "Dataset", "Format", "Schedule", "Destination", "Last Run", "Last Key", "Rows", "Last Error"}, rows)
+	})
+}
+
+// runExport runs an export on the server
+func (c *ExportsCommand) runExport(ctx context.Context, name string) error {
+	run, err := c.client.RunExport(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to run export: %w", err)
+	}
+	return c.formatter.PrintResult(run, func() {
+		c.formatter.PrintSuccess(fmt.Sprintf("Export %s wrote %d rows of %s as %s to %s (%d bytes)", run.Export, run.Rows, run.Dataset, run.Key, run.Destination, run.Size))
+	})
+}
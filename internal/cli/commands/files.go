@@ -22,8 +22,11 @@ type VersionCommand struct {
 func NewVersionCommand(client *client.Client, formatter *formatter.Formatter, versionManager *files.VersionManager) *VersionCommand {
 	return &VersionCommand{
 		BaseCommand: BaseCommand{
-			client:    client,
-			formatter: formatter,
+			name:        "version",
+			description: "File versioning operations",
+			usage:       "version [create|list|current|restore|delete|compare|all] [args...]",
+			client:      client,
+			formatter:   formatter,
 		},
 		versionManager: versionManager,
 	}
@@ -268,8 +271,11 @@ type ShareCommand struct {
 func NewShareCommand(client *client.Client, formatter *formatter.Formatter, shareManager *files.ShareManager) *ShareCommand {
 	return &ShareCommand{
 		BaseCommand: BaseCommand{
-			client:    client,
-			formatter: formatter,
+			name:        "share",
+			description: "File sharing operations",
+			usage:       "share [create|public|links|revoke-link|get|list|update|revoke|delete|stats] [args...]",
+			client:      client,
+			formatter:   formatter,
 		},
 		shareManager: shareManager,
 	}
@@ -288,7 +294,11 @@ func (c *ShareCommand) Execute(ctx context.Context, args []string) error {
 	case "create":
 		return c.createShare(subArgs)
 	case "public":
-		return c.createPublicShare(subArgs)
+		return c.createPublicShare(ctx, subArgs)
+	case "links":
+		return c.listLinks(ctx, subArgs)
+	case "revoke-link":
+		return c.revokeLink(ctx, subArgs)
 	case "get":
 		return c.getShare(subArgs)
 	case "list":
@@ -341,40 +351,128 @@ func (c *ShareCommand) createShare(args []string) error {
 	return nil
 }
 
-func (c *ShareCommand) createPublicShare(args []string) error {
-	if len(args) < 3 {
-		return fmt.Errorf("usage: share public <file_id> <shared_by> <permissions> [expires_in]")
+// createPublicShare issues a signed link on the node that anyone holding
+// the URL can download from until it expires or is revoked
+func (c *ShareCommand) createPublicShare(ctx context.Context, args []string) error {
+	usage := "usage: share public <file_id> [expires_in] [--max-downloads N] [--password P]"
+	if len(args) < 1 {
+		return fmt.Errorf("%s", usage)
 	}
 
-	fileID := args[0]
-	sharedBy := args[1]
-	permissions := strings.Split(args[2], ",")
-
-	expiresIn := 24 * time.Hour // Default 24 hours
-	if len(args) > 3 {
-		duration, err := time.ParseDuration(args[3])
-		if err != nil {
-			return fmt.Errorf("invalid expires_in duration: %w", err)
+	opts := &client.ShareLinkOptions{Key: args[0]}
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "--max-downloads", "--password":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a value", args[i])
+			}
+			if args[i] == "--password" {
+				opts.Password = args[i+1]
+			} else {
+				n, err := strconv.Atoi(args[i+1])
+				if err != nil || n < 0 {
+					return fmt.Errorf("invalid --max-downloads value: %s", args[i+1])
+				}
+				opts.MaxDownloads = n
+			}
+			i++
+		default:
+			if opts.ExpiresIn != "" {
+				return fmt.Errorf("%s", usage)
+			}
+			opts.ExpiresIn = args[i]
 		}
-		expiresIn = duration
 	}
 
-	share, err := c.shareManager.ShareFilePublicly(fileID, sharedBy, permissions, expiresIn)
+	link, err := c.client.CreateShareLink(ctx, opts)
 	if err != nil {
 		return fmt.Errorf("failed to create public share: %w", err)
 	}
 
 	c.formatter.PrintSuccess("File shared publicly")
-	c.formatter.PrintInfo("Share ID: " + share.ID)
-	c.formatter.PrintInfo("File ID: " + share.FileID)
-	c.formatter.PrintInfo("Shared by: " + share.SharedBy)
-	c.formatter.PrintInfo("Permissions: " + strings.Join(share.Permissions, ", "))
-	c.formatter.PrintInfo("Public URL: " + share.PublicURL)
-	c.formatter.PrintInfo("Expires: " + share.ExpiresAt.Format(time.RFC3339))
+	c.printLink(link)
+	return nil
+}
+
+// listLinks lists the signed links issued by the node
+func (c *ShareCommand) listLinks(ctx context.Context, args []string) error {
+	fileID := ""
+	if len(args) > 0 {
+		fileID = args[0]
+	}
+
+	links, err := c.client.ListShareLinks(ctx, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to list share links: %w", err)
+	}
+	if links.Total == 0 {
+		c.formatter.PrintInfo("No share links found")
+		return nil
+	}
+
+	c.formatter.PrintTable([]string{"Link ID", "File ID", "Downloads", "Password", "Status", "Expires"},
+		func() [][]string {
+			var rows [][]string
+			for _, link := range links.Links {
+				downloads := strconv.Itoa(link.Downloads)
+				if link.MaxDownloads > 0 {
+					downloads += "/" + strconv.Itoa(link.MaxDownloads)
+				}
+				rows = append(rows, []string{
+					link.ID,
+					link.Key,
+					downloads,
+					strconv.FormatBool(link.PasswordProtected),
+					linkStatus(&link),
+					link.ExpiresAt.Format("2006-01-02 15:04:05"),
+				})
+			}
+			return rows
+		}())
+
+	return nil
+}
 
+// revokeLink disables a signed link on the node
+func (c *ShareCommand) revokeLink(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: share revoke-link <link_id>")
+	}
+
+	link, err := c.client.RevokeShareLink(ctx, args[0])
+	if err != nil {
+		return fmt.Errorf("failed to revoke share link: %w", err)
+	}
+
+	c.formatter.PrintSuccess("Share link revoked successfully")
+	c.formatter.PrintInfo("Link ID: " + link.ID)
 	return nil
 }
 
+func (c *ShareCommand) printLink(link *client.ShareLink) {
+	c.formatter.PrintInfo("Link ID: " + link.ID)
+	c.formatter.PrintInfo("File ID: " + link.Key)
+	c.formatter.PrintInfo("Public URL: " + link.URL)
+	if link.MaxDownloads > 0 {
+		c.formatter.PrintInfo("Max downloads: " + strconv.Itoa(link.MaxDownloads))
+	}
+	c.formatter.PrintInfo("Password protected: " + strconv.FormatBool(link.PasswordProtected))
+	c.formatter.PrintInfo("Expires: " + link.ExpiresAt.Format(time.RFC3339))
+}
+
+func linkStatus(link *client.ShareLink) string {
+	switch {
+	case link.Revoked:
+		return "revoked"
+	case link.Active:
+		return "active"
+	case link.MaxDownloads > 0 && link.Downloads >= link.MaxDownloads:
+		return "exhausted"
+	default:
+		return "expired"
+	}
+}
+
 func (c *ShareCommand) getShare(args []string) error {
 	if len(args) < 1 {
 		return fmt.Errorf("usage: share get <share_id>")
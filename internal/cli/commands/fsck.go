@@ -0,0 +1,60 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// FsckCommand checks the invariants of the server's store
+type FsckCommand struct {
+	BaseCommand
+}
+
+// NewFsckCommand creates a new fsck command
+func NewFsckCommand(client *client.Client, formatter *formatter.Formatter) *FsckCommand {
+	return &FsckCommand{
+		BaseCommand: BaseCommand{
+			name:        "fsck",
+			description: "Check the server's store for missing, unreferenced or misplaced chunks",
+			usage:       "fsck",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the fsck command. It fails when an invariant is broken,
+// so scripts can tell from the exit status.
+func (c *FsckCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: %s", c.usage)
+	}
+
+	check, err := c.client.CheckStorage(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check storage: %w", err)
+	}
+	err = c.formatter.PrintResult(check, func() {
+		summary := fmt.Sprintf("Checked %d objects and %d chunks in the %s layout", check.Objects, check.Chunks, check.Format)
+		if len(check.Violations) == 0 {
+			c.formatter.PrintSuccess(summary + "; no problems found")
+			return
+		}
+		c.formatter.PrintWarning(summary)
+		rows := make([][]string, len(check.Violations))
+		for i, v := range check.Violations {
+			rows[i] = []string{v.Invariant, v.Problem, v.Path, v.Detail}
+		}
+		c.formatter.PrintTable([]string{"Invariant", "Problem", "Path", "Detail"}, rows)
+	})
+	if err != nil {
+		return err
+	}
+	if len(check.Violations) > 0 {
+		return fmt.Errorf("%d broken invariants found", len(check.Violations))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+	"github.com/Skpow1234/Peervault/internal/hooks"
+)
+
+// HooksCommand shows the WebAssembly hooks of the node and checks hook
+// files before they are deployed
+type HooksCommand struct {
+	BaseCommand
+}
+
+// NewHooksCommand creates a new hooks command
+func NewHooksCommand(client *client.Client, formatter *formatter.Formatter) *HooksCommand {
+	return &HooksCommand{
+		BaseCommand: BaseCommand{
+			name:        "hooks",
+			description: "Show lifecycle hooks and their counters, or check a hooks file locally",
+			usage:       "hooks [list|check <hooks.yaml>]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the hooks command
+func (c *HooksCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return c.listHooks(ctx)
+	}
+
+	subcommand := strings.ToLower(args[0])
+	switch subcommand {
+	case "list", "ls":
+		return c.listHooks(ctx)
+	case "check":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: hooks check <hooks.yaml>")
+		}
+		return c.check(args[1])
+	default:
+		return fmt.Errorf("unknown subcommand: %s", subcommand)
+	}
+}
+
+// listHooks prints the hooks the server runs
+func (c *HooksCommand) listHooks(ctx context.Context) error {
+	list, err := c.client.ListHooks(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list hooks: %w", err)
+	}
+	return c.formatter.PrintResult(list, func() {
+		if len(list.Hooks) == 0 {
+			c.formatter.PrintInfo("No hooks configured")
+			return
+		}
+		rows := make([][]string, len(list.Hooks))
+		for i, h := range list.Hooks {
+			avg := "-"
+			if h.Calls > 0 {
+				avg = (h.Duration / time.Duration(h.Calls)).Round(time.Microsecond).String()
+			}
+			rows[i] = []string{
+				h.Name, h.Point,
+				strconv.FormatUint(h.Calls, 10),
+				strconv.FormatUint(h.Rejections, 10),
+				strconv.FormatUint(h.Errors, 10),
+				strconv.FormatUint(h.Timeouts, 10),
+				avg, orDash(h.LastError),
+			}
+		}
+		c.formatter.PrintTable([]string{"Hook", "Point", "Calls", "Rejected", "Errors", "Timeouts", "Avg Time", "Last Error"}, rows)
+	})
+}
+
+// check loads a hooks file and compiles its modules
+func (c *HooksCommand) check(path string) error {
+	cfg, err := hooks.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	engine, err := hooks.New(cfg)
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+	c.formatter.PrintSuccess(fmt.Sprintf("%s: %d hook(s) compiled", path, len(engine.Stats())))
+	return nil
+}
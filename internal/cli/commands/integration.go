@@ -24,7 +24,7 @@ func NewIntegrationCommand(client *client.Client, formatter *formatter.Formatter
 		BaseCommand: BaseCommand{
 			name:        "integration",
 			description: "Integration and automation operations",
-			usage:       "integration [command] [options]",
+			usage:       "integration [webhook|workflow|api|gateway|sync|stats|help] [options]",
 			client:      client,
 			formatter:   formatter,
 		},
@@ -24,7 +24,7 @@ func NewIoTCommand(client *client.Client, formatter *formatter.Formatter, device
 		BaseCommand: BaseCommand{
 			name:        "iot",
 			description: "IoT device operations",
-			usage:       "iot [command] [options]",
+			usage:       "iot [add-device|remove-device|list-devices|get-device|update-status|send-sensor-data|get-sensor-data|send-command|get-commands|schedule-update|get-updates|update-progress|stats] [options]",
 			client:      client,
 			formatter:   formatter,
 		},
@@ -0,0 +1,159 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// JobsCommand lists the periodic jobs of the server, changes their
+// schedules and runs them by hand
+type JobsCommand struct {
+	BaseCommand
+}
+
+// NewJobsCommand creates a new jobs command
+func NewJobsCommand(client *client.Client, formatter *formatter.Formatter) *JobsCommand {
+	return &JobsCommand{
+		BaseCommand: BaseCommand{
+			name:        "jobs",
+			description: "List, run and reschedule the server's periodic jobs (reports, key rotation, lifecycle, backups)",
+			usage:       "jobs [list|show <name>|run <name>|schedule <name> <cron|default>|pause <name>|resume <name>]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the jobs command
+func (c *JobsCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return c.list(ctx)
+	}
+
+	subcommand := strings.ToLower(args[0])
+	switch subcommand {
+	case "list", "ls":
+		return c.list(ctx)
+	case "show", "get":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: jobs show <name>")
+		}
+		return c.show(ctx, args[1])
+	case "run":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: jobs run <name>")
+		}
+		return c.run(ctx, args[1])
+	case "schedule":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: jobs schedule <name> <cron|default>")
+		}
+		// Cron expressions are several arguments unless quoted
+		schedule := strings.Join(args[2:], " ")
+		if schedule == "default" {
+			schedule = ""
+		}
+		return c.update(ctx, args[1], &client.JobUpdate{Schedule: &schedule})
+	case "pause", "resume":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: jobs %s <name>", subcommand)
+		}
+		paused := subcommand == "pause"
+		return c.update(ctx, args[1], &client.JobUpdate{Paused: &paused})
+	default:
+		return fmt.Errorf("unknown subcommand: %s", subcommand)
+	}
+}
+
+func (c *JobsCommand) list(ctx context.Context) error {
+	list, err := c.client.ListJobs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list jobs: %w", err)
+	}
+	return c.formatter.PrintResult(list, func() {
+		if len(list.Jobs) == 0 {
+			c.formatter.PrintInfo("No jobs scheduled")
+			return
+		}
+		rows := make([][]string, len(list.Jobs))
+		for i, job := range list.Jobs {
+			last, status := "-", "-"
+			if job.LastRun != nil {
+				last, status = formatTime(job.LastRun.StartedAt), job.LastRun.Status
+			}
+			rows[i] = []string{job.Name, jobSchedule(job), formatTime(job.NextRun), last, status}
+		}
+		c.formatter.PrintTable([]string{"Job", "Schedule", "Next Run", "Last Run", "Status"}, rows)
+	})
+}
+
+func (c *JobsCommand) show(ctx context.Context, name string) error {
+	job, err := c.client.GetJob(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to get job: %w", err)
+	}
+	return c.formatter.PrintResult(job, func() {
+		c.printJob(job)
+		if len(job.Runs) == 0 {
+			c.formatter.PrintInfo("The job has not run yet")
+			return
+		}
+		rows := make([][]string, len(job.Runs))
+		for i, r := range job.Runs {
+			rows[i] = []string{r.ID, r.Trigger, r.Status, formatTime(r.StartedAt), formatTime(r.FinishedAt), orDash(r.Error)}
+		}
+		c.formatter.PrintTable([]string{"Run", "Trigger", "Status", "Started", "Finished", "Error"}, rows)
+	})
+}
+
+func (c *JobsCommand) run(ctx context.Context, name string) error {
+	run, err := c.client.RunJob(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to run job: %w", err)
+	}
+	return c.formatter.PrintResult(run, func() {
+		c.formatter.PrintSuccess(fmt.Sprintf("Job %s started (run %s)", name, run.ID))
+		c.formatter.PrintInfo("Check its outcome with: jobs show " + name)
+	})
+}
+
+func (c *JobsCommand) update(ctx context.Context, name string, update *client.JobUpdate) error {
+	job, err := c.client.UpdateJob(ctx, name, update)
+	if err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+	return c.formatter.PrintResult(job, func() {
+		c.formatter.PrintSuccess(fmt.Sprintf("Job %s updated", name))
+		c.printJob(job)
+	})
+}
+
+func (c *JobsCommand) printJob(job *client.ScheduledJob) {
+	rows := [][]string{
+		{"Name", job.Name},
+		{"Description", orDash(job.Description)},
+		{"Schedule", jobSchedule(*job)},
+		{"Default Schedule", orDash(job.DefaultSchedule)},
+		{"Next Run", formatTime(job.NextRun)},
+		{"Running", fmt.Sprintf("%t", job.Running)},
+	}
+	if job.Jitter != "" {
+		rows = append(rows, []string{"Jitter", job.Jitter})
+	}
+	c.formatter.PrintTable([]string{"Field", "Value"}, rows)
+}
+
+// jobSchedule describes when a job runs
+func jobSchedule(job client.ScheduledJob) string {
+	switch {
+	case job.Schedule == "":
+		return "manual"
+	case job.Paused:
+		return job.Schedule + " (paused)"
+	}
+	return job.Schedule
+}
@@ -0,0 +1,119 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// JSONCommand reads and updates JSON documents on the server
+type JSONCommand struct {
+	BaseCommand
+}
+
+// NewJSONCommand creates a new json command
+func NewJSONCommand(client *client.Client, formatter *formatter.Formatter) *JSONCommand {
+	return &JSONCommand{
+		BaseCommand: BaseCommand{
+			name:        "json",
+			description: "Read, write and patch JSON documents",
+			usage:       "json get <key> | json put <key> <json|file|-> [--if-match <etag>] [--create] | json patch <key> <json|file|-> [--merge] [--if-match <etag>] | json delete <key> [--if-match <etag>]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the json command
+func (c *JSONCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: %s", c.usage)
+	}
+	action, key := args[0], args[1]
+
+	var body []byte
+	rest := args[2:]
+	if action == "put" || action == "patch" {
+		if len(rest) == 0 {
+			return fmt.Errorf("%s needs a document; usage: %s", action, c.usage)
+		}
+		data, err := readJSONArg(rest[0])
+		if err != nil {
+			return err
+		}
+		body, rest = data, rest[1:]
+	}
+
+	var w client.JSONWrite
+	patchType := client.JSONPatch
+	for i := 0; i < len(rest); i++ {
+		switch arg := rest[i]; arg {
+		case "--if-match":
+			if i+1 >= len(rest) {
+				return fmt.Errorf("--if-match needs a value")
+			}
+			i++
+			w.IfMatch = rest[i]
+		case "--create":
+			w.Create = true
+		case "--merge":
+			patchType = client.JSONMergePatch
+		default:
+			return fmt.Errorf("unknown argument %q; usage: %s", arg, c.usage)
+		}
+	}
+
+	var doc *client.JSONDocument
+	var err error
+	switch action {
+	case "get":
+		doc, err = c.client.GetJSON(ctx, key)
+	case "put":
+		doc, err = c.client.PutJSON(ctx, key, body, w)
+	case "patch":
+		doc, err = c.client.PatchJSON(ctx, key, patchType, body, w)
+	case "delete":
+		if err := c.client.DeleteJSON(ctx, key, w); err != nil {
+			return fmt.Errorf("delete failed: %w", err)
+		}
+		c.formatter.PrintSuccess(fmt.Sprintf("Deleted %s", key))
+		return nil
+	default:
+		return fmt.Errorf("unknown action %q; usage: %s", action, c.usage)
+	}
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", action, err)
+	}
+
+	var out bytes.Buffer
+	if json.Indent(&out, doc.Data, "", "  ") != nil {
+		out.Reset()
+		out.Write(doc.Data)
+	}
+	fmt.Println(out.String())
+	c.formatter.PrintInfo(fmt.Sprintf("ETag: %s", doc.ETag))
+	return nil
+}
+
+// readJSONArg reads a document given inline, from a file or, for "-",
+// from standard input
+func readJSONArg(arg string) ([]byte, error) {
+	if arg == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	if trimmed := strings.TrimSpace(arg); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		return []byte(arg), nil
+	}
+	data, err := os.ReadFile(arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", arg, err)
+	}
+	return data, nil
+}
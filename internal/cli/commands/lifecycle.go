@@ -0,0 +1,196 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// LifecycleCommand manages lifecycle rules and previews their effect
+type LifecycleCommand struct {
+	BaseCommand
+}
+
+// NewLifecycleCommand creates a new lifecycle command
+func NewLifecycleCommand(client *client.Client, formatter *formatter.Formatter) *LifecycleCommand {
+	return &LifecycleCommand{
+		BaseCommand: BaseCommand{
+			name:        "lifecycle",
+			description: "Manage lifecycle rules (expiry, cold tiering, old versions)",
+			usage:       "lifecycle [policy|policy set <rules.json>|dry-run|run --confirm|report]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the lifecycle command
+func (c *LifecycleCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return c.showPolicy(ctx)
+	}
+
+	subcommand := strings.ToLower(args[0])
+	switch subcommand {
+	case "policy":
+		if len(args) >= 2 && strings.ToLower(args[1]) == "set" {
+			if len(args) < 3 {
+				return fmt.Errorf("usage: lifecycle policy set <rules.json>")
+			}
+			return c.setPolicy(ctx, args[2])
+		}
+		return c.showPolicy(ctx)
+	case "dry-run", "plan":
+		return c.run(ctx, true)
+	case "run":
+		if !contains(args[1:], "--confirm") {
+			// Show what would happen instead of acting on a bare "run"
+			if err := c.run(ctx, true); err != nil {
+				return err
+			}
+			c.formatter.PrintWarning("Nothing was changed; re-run with --confirm to apply these actions")
+			return nil
+		}
+		return c.run(ctx, false)
+	case "report":
+		return c.showReport(ctx)
+	default:
+		return fmt.Errorf("unknown subcommand: %s", subcommand)
+	}
+}
+
+// showPolicy prints the active rules
+func (c *LifecycleCommand) showPolicy(ctx context.Context) error {
+	policy, err := c.client.GetLifecyclePolicy(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get lifecycle policy: %w", err)
+	}
+	return c.printPolicy(policy)
+}
+
+// setPolicy replaces the policy with the rules in a JSON file, which holds
+// either {"rules": [...]} or a bare array of rules
+func (c *LifecycleCommand) setPolicy(ctx context.Context, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var policy client.LifecyclePolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		if err := json.Unmarshal(data, &policy.Rules); err != nil {
+			return fmt.Errorf("invalid lifecycle rules in %s: %w", path, err)
+		}
+	}
+
+	updated, err := c.client.SetLifecyclePolicy(ctx, policy.Rules)
+	if err != nil {
+		return fmt.Errorf("failed to set lifecycle policy: %w", err)
+	}
+	c.formatter.PrintSuccess(fmt.Sprintf("Lifecycle policy updated with %d rule(s)", len(updated.Rules)))
+	if err := c.printPolicy(updated); err != nil {
+		return err
+	}
+	c.formatter.PrintInfo("Preview its effect with: lifecycle dry-run")
+	return nil
+}
+
+// run evaluates the policy on the server
+func (c *LifecycleCommand) run(ctx context.Context, dryRun bool) error {
+	report, err := c.client.RunLifecycle(ctx, dryRun)
+	if err != nil {
+		return fmt.Errorf("lifecycle run failed: %w", err)
+	}
+	return c.printReport(report)
+}
+
+// showReport prints the report of the last run, scheduled or manual
+func (c *LifecycleCommand) showReport(ctx context.Context) error {
+	report, err := c.client.GetLifecycleReport(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get lifecycle report: %w", err)
+	}
+	return c.printReport(report)
+}
+
+func (c *LifecycleCommand) printPolicy(policy *client.LifecyclePolicy) error {
+	return c.formatter.PrintResult(policy, func() {
+		if len(policy.Rules) == 0 {
+			c.formatter.PrintInfo("No lifecycle rules configured")
+			return
+		}
+
+		rows := make([][]string, len(policy.Rules))
+		for i, r := range policy.Rules {
+			scope := "*"
+			if r.Prefix != "" {
+				scope = r.Prefix + "*"
+			}
+			if r.Tenant != "" {
+				scope += " (tenant " + r.Tenant + ")"
+			}
+			transition := "-"
+			if r.TransitionAfterDays > 0 {
+				transition = fmt.Sprintf("%s after %dd", r.TransitionTo, r.TransitionAfterDays)
+			}
+			rows[i] = []string{r.ID, scope, formatDays(r.ExpireAfterDays), transition, formatDays(r.NoncurrentExpireAfterDays), fmt.Sprintf("%t", !r.Disabled)}
+		}
+		c.formatter.PrintTable([]string{"Rule", "Scope", "Expire", "Transition", "Old Versions", "Enabled"}, rows)
+
+		if policy.Enforcing {
+			c.formatter.PrintInfo("Scheduled runs enforce this policy")
+		} else {
+			c.formatter.PrintInfo("Scheduled runs only report; start the API with -lifecycle-enforce to apply them")
+		}
+	})
+
+}
+
+func (c *LifecycleCommand) printReport(report *client.LifecycleReport) error {
+	return c.formatter.PrintResult(report, func() {
+		mode := "Enforced"
+		if report.DryRun {
+			mode = "Dry run"
+		}
+		c.formatter.PrintHeader(fmt.Sprintf("Lifecycle %s — %s", strings.ToLower(mode), report.StartedAt.Format("2006-01-02 15:04:05")))
+
+		if len(report.Actions) > 0 {
+			rows := make([][]string, len(report.Actions))
+			for i, a := range report.Actions {
+				target := a.Key
+				if a.VersionID != "" {
+					target += " @" + a.VersionID
+				}
+				detail := a.StorageClass
+				if a.Error != "" {
+					detail = a.Error
+				}
+				rows[i] = []string{a.Type, target, a.RuleID, fmt.Sprintf("%dd", a.AgeDays), c.formatter.FormatBytes(a.Size), a.Status, detail}
+			}
+			c.formatter.PrintTable([]string{"Action", "Object", "Rule", "Age", "Size", "Status", "Detail"}, rows)
+		}
+
+		s := report.Summary
+		c.formatter.PrintTable([]string{"Field", "Value"}, [][]string{
+			{"Objects", fmt.Sprintf("%d", report.Objects)},
+			{"Rules", fmt.Sprintf("%d", report.Rules)},
+			{"Expired", fmt.Sprintf("%d (%s)", s.Expired, c.formatter.FormatBytes(s.BytesExpired))},
+			{"Transitioned", fmt.Sprintf("%d (%s)", s.Transitioned, c.formatter.FormatBytes(s.BytesTransitioned))},
+			{"Versions Deleted", fmt.Sprintf("%d (%s)", s.VersionsDeleted, c.formatter.FormatBytes(s.BytesVersionsDeleted))},
+			{"Failed", fmt.Sprintf("%d", s.Failed)},
+		})
+	})
+
+}
+
+func formatDays(n int) string {
+	if n <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%dd", n)
+}
@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// LockCommand manages retention locks and legal holds
+type LockCommand struct {
+	BaseCommand
+}
+
+// NewLockCommand creates a new lock command
+func NewLockCommand(client *client.Client, formatter *formatter.Formatter) *LockCommand {
+	return &LockCommand{
+		BaseCommand: BaseCommand{
+			name:        "lock",
+			description: "Make files immutable with retention periods and legal holds",
+			usage:       "lock [list|status <file_id>|retain <file_id> <30d|RFC3339>|hold <file_id>|release <file_id>]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the lock command
+func (c *LockCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return c.list(ctx)
+	}
+
+	subcommand := strings.ToLower(args[0])
+	switch subcommand {
+	case "list":
+		return c.list(ctx)
+	case "status":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: lock status <file_id>")
+		}
+		lock, err := c.client.GetFileLock(ctx, args[1])
+		if err != nil {
+			return fmt.Errorf("failed to get lock: %w", err)
+		}
+		return c.printLock(lock)
+	case "retain":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: lock retain <file_id> <period|retain_until>")
+		}
+		return c.update(ctx, args[1], &client.FileLockUpdate{RetainUntil: args[2]},
+			"Retention can be extended later but never shortened")
+	case "hold", "release":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: lock %s <file_id>", subcommand)
+		}
+		hold := subcommand == "hold"
+		return c.update(ctx, args[1], &client.FileLockUpdate{LegalHold: &hold}, "")
+	default:
+		return fmt.Errorf("unknown subcommand: %s", subcommand)
+	}
+}
+
+// list prints every lock in force
+func (c *LockCommand) list(ctx context.Context) error {
+	locks, err := c.client.ListFileLocks(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list locks: %w", err)
+	}
+	return c.formatter.PrintResult(locks, func() {
+		if locks.Total == 0 {
+			c.formatter.PrintInfo("No files are locked")
+			return
+		}
+
+		rows := make([][]string, len(locks.Locks))
+		for i, lock := range locks.Locks {
+			rows[i] = []string{lock.Key, retainUntil(&lock), fmt.Sprintf("%t", lock.LegalHold), lock.UpdatedBy}
+		}
+		c.formatter.PrintTable([]string{"File", "Retained Until", "Legal Hold", "Updated By"}, rows)
+	})
+}
+
+func (c *LockCommand) update(ctx context.Context, fileID string, update *client.FileLockUpdate, note string) error {
+	lock, err := c.client.UpdateFileLock(ctx, fileID, update)
+	if err != nil {
+		return fmt.Errorf("failed to update lock: %w", err)
+	}
+	c.formatter.PrintSuccess(fmt.Sprintf("Lock updated for %s", fileID))
+	if err := c.printLock(lock); err != nil {
+		return err
+	}
+	if note != "" {
+		c.formatter.PrintInfo(note)
+	}
+	return nil
+}
+
+func (c *LockCommand) printLock(lock *client.FileLock) error {
+	return c.formatter.PrintResult(lock, func() {
+		rows := [][]string{
+			{"File", lock.Key},
+			{"Locked", fmt.Sprintf("%t", lock.Locked)},
+			{"Retained Until", retainUntil(lock)},
+			{"Legal Hold", fmt.Sprintf("%t", lock.LegalHold)},
+		}
+		if lock.UpdatedAt != nil {
+			rows = append(rows, []string{"Updated", fmt.Sprintf("%s by %s", lock.UpdatedAt.Format("2006-01-02 15:04:05"), lock.UpdatedBy)})
+		}
+		c.formatter.PrintTable([]string{"Field", "Value"}, rows)
+	})
+}
+
+func retainUntil(lock *client.FileLock) string {
+	if lock.RetainUntil == nil {
+		return "-"
+	}
+	return lock.RetainUntil.Format("2006-01-02 15:04:05")
+}
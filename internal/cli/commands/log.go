@@ -0,0 +1,207 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// LogCommand appends to, reads and follows append-only logs
+type LogCommand struct {
+	BaseCommand
+}
+
+// NewLogCommand creates a new log command
+func NewLogCommand(client *client.Client, formatter *formatter.Formatter) *LogCommand {
+	return &LogCommand{
+		BaseCommand: BaseCommand{
+			name:        "log",
+			description: "Append to, read and follow append-only logs",
+			usage:       "log list | log info <name> | log append <name> <record|-> | log read <name> [--offset N] [--since TIME] [--limit N] [--follow] | log truncate <name> [--before-offset N] [--before-time TIME] | log delete <name>",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the log command
+func (c *LogCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 1 && args[0] == "list" {
+		return c.list(ctx)
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("usage: %s", c.usage)
+	}
+	action, name, rest := args[0], args[1], args[2:]
+
+	switch action {
+	case "info":
+		info, err := c.client.GetLog(ctx, name)
+		if err != nil {
+			return fmt.Errorf("info failed: %w", err)
+		}
+		c.printInfo(info)
+		return nil
+	case "append":
+		return c.append(ctx, name, rest)
+	case "read":
+		return c.read(ctx, name, rest)
+	case "truncate":
+		return c.truncate(ctx, name, rest)
+	case "delete":
+		if err := c.client.DeleteLog(ctx, name); err != nil {
+			return fmt.Errorf("delete failed: %w", err)
+		}
+		c.formatter.PrintSuccess(fmt.Sprintf("Deleted log %s", name))
+		return nil
+	}
+	return fmt.Errorf("unknown action %q; usage: %s", action, c.usage)
+}
+
+func (c *LogCommand) list(ctx context.Context) error {
+	logs, err := c.client.ListLogs(ctx)
+	if err != nil {
+		return fmt.Errorf("list failed: %w", err)
+	}
+	if len(logs) == 0 {
+		c.formatter.PrintInfo("No logs")
+		return nil
+	}
+	for i := range logs {
+		c.printInfo(&logs[i])
+	}
+	return nil
+}
+
+func (c *LogCommand) printInfo(info *client.LogInfo) {
+	fmt.Printf("%s\toffsets %d-%d\t%d records\t%d bytes\t%d segments\n",
+		info.Name, info.StartOffset, info.NextOffset, info.Records, info.Bytes, info.Segments)
+}
+
+// append appends one record given as an argument, or each line of
+// standard input for "-"
+func (c *LogCommand) append(ctx context.Context, name string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("append needs one record; usage: %s", c.usage)
+	}
+	records := [][]byte{[]byte(args[0])}
+	if args[0] == "-" {
+		records = records[:0]
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Buffer(nil, 1<<20+1)
+		for scanner.Scan() {
+			if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+				records = append(records, bytes.Clone(line))
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("failed to read standard input: %w", err)
+		}
+		if len(records) == 0 {
+			return fmt.Errorf("no records on standard input")
+		}
+	}
+
+	result, err := c.client.AppendLog(ctx, name, records...)
+	if err != nil {
+		return fmt.Errorf("append failed: %w", err)
+	}
+	first := result.Records[0].Offset
+	c.formatter.PrintSuccess(fmt.Sprintf("Appended %d records to %s at offsets %d-%d", len(result.Records), name, first, result.NextOffset-1))
+	return nil
+}
+
+func (c *LogCommand) read(ctx context.Context, name string, args []string) error {
+	var q client.LogQuery
+	follow := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--follow" {
+			follow = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return fmt.Errorf("unknown argument %q; usage: %s", arg, c.usage)
+		}
+		i++
+		var err error
+		switch arg {
+		case "--offset":
+			q.Offset, err = strconv.ParseUint(args[i], 10, 64)
+		case "--since":
+			q.Since, err = parseTime(args[i])
+		case "--limit":
+			q.Limit, err = strconv.Atoi(args[i])
+		default:
+			return fmt.Errorf("unknown argument %q; usage: %s", arg, c.usage)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", arg, err)
+		}
+	}
+
+	show := func(rec client.LogRecord) error {
+		fmt.Printf("%d\t%s\t%s\n", rec.Offset, rec.Time.Format(time.RFC3339), rec.Data)
+		return nil
+	}
+	if follow {
+		return c.client.FollowLog(ctx, name, q, show)
+	}
+	result, err := c.client.ReadLog(ctx, name, q)
+	if err != nil {
+		return fmt.Errorf("read failed: %w", err)
+	}
+	for _, rec := range result.Records {
+		_ = show(rec)
+	}
+	return nil
+}
+
+func (c *LogCommand) truncate(ctx context.Context, name string, args []string) error {
+	var offset uint64
+	var before time.Time
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if i+1 >= len(args) {
+			return fmt.Errorf("%s needs a value", arg)
+		}
+		i++
+		var err error
+		switch arg {
+		case "--before-offset":
+			offset, err = strconv.ParseUint(args[i], 10, 64)
+		case "--before-time":
+			before, err = parseTime(args[i])
+		default:
+			return fmt.Errorf("unknown argument %q; usage: %s", arg, c.usage)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", arg, err)
+		}
+	}
+	if offset == 0 && before.IsZero() {
+		return fmt.Errorf("truncate needs --before-offset or --before-time")
+	}
+
+	info, err := c.client.TruncateLog(ctx, name, offset, before)
+	if err != nil {
+		return fmt.Errorf("truncate failed: %w", err)
+	}
+	c.formatter.PrintSuccess(fmt.Sprintf("Log %s now starts at offset %d", name, info.StartOffset))
+	return nil
+}
+
+// parseTime reads an RFC 3339 time, or a duration meaning that long ago
+func parseTime(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
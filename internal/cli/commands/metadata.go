@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// MetadataCommand manages metadata replication and failover
+type MetadataCommand struct {
+	BaseCommand
+}
+
+// NewMetadataCommand creates a new metadata command
+func NewMetadataCommand(client *client.Client, formatter *formatter.Formatter) *MetadataCommand {
+	return &MetadataCommand{
+		BaseCommand: BaseCommand{
+			name:        "metadata",
+			description: "Inspect metadata replication and promote a hot standby",
+			usage:       "metadata [status|promote --confirm]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the metadata command
+func (c *MetadataCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return c.showStatus(ctx)
+	}
+
+	subcommand := strings.ToLower(args[0])
+	switch subcommand {
+	case "status":
+		return c.showStatus(ctx)
+	case "promote":
+		return c.promote(ctx, args[1:])
+	default:
+		return fmt.Errorf("unknown subcommand: %s", subcommand)
+	}
+}
+
+// showStatus prints the replication status of the connected node
+func (c *MetadataCommand) showStatus(ctx context.Context) error {
+	status, err := c.client.GetMetadataStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get metadata status: %w", err)
+	}
+
+	return c.formatter.PrintResult(status, func() {
+		rows := [][]string{
+			{"Role", status.Role},
+			{"Epoch", fmt.Sprintf("%d", status.Epoch)},
+			{"Applied Index", fmt.Sprintf("%d", status.AppliedIndex)},
+		}
+		if status.PrimaryURL != "" {
+			rows = append(rows,
+				[]string{"Primary", status.PrimaryURL},
+				[]string{"Connected", fmt.Sprintf("%t", status.Connected)},
+				[]string{"Primary Index", fmt.Sprintf("%d", status.PrimaryIndex)},
+				[]string{"Lag", fmt.Sprintf("%d entries", status.Lag)},
+			)
+		}
+		c.formatter.PrintTable([]string{"Field", "Value"}, rows)
+	})
+}
+
+// promote promotes the connected standby to primary
+func (c *MetadataCommand) promote(ctx context.Context, args []string) error {
+	if !contains(args, "--confirm") {
+		return fmt.Errorf("promotion fences the current primary; re-run with --confirm")
+	}
+
+	result, err := c.client.PromoteMetadataStandby(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to promote standby: %w", err)
+	}
+
+	c.formatter.PrintSuccess(fmt.Sprintf("Standby promoted to %s (epoch %d, index %d)", result.Role, result.Epoch, result.Index))
+	return nil
+}
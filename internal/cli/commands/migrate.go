@@ -0,0 +1,216 @@
+package commands
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+	"github.com/Skpow1234/Peervault/internal/cli/migrate"
+)
+
+// ImportCommand bulk-loads a directory, tar archive or S3 prefix
+type ImportCommand struct {
+	BaseCommand
+}
+
+// NewImportCommand creates a new import command
+func NewImportCommand(client *client.Client, formatter *formatter.Formatter) *ImportCommand {
+	return &ImportCommand{
+		BaseCommand: BaseCommand{
+			name:        "import",
+			description: "Bulk-import a directory, tar archive or S3 prefix, resuming where a previous run stopped",
+			usage:       "import <dir|file.tar[.gz]|s3://bucket/prefix> [--tag t] [--workers N] [--retries N] [--journal path] [--fresh] [--region r] [--endpoint url]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the import command
+func (c *ImportCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s", c.usage)
+	}
+	src, err := migrate.ParseLocation(args[0])
+	if err != nil {
+		return err
+	}
+	opts, _, err := parseMigrateOptions("import", src, args[1:], false)
+	if err != nil {
+		return err
+	}
+
+	bar := newMigrateProgress(c.formatter, &opts)
+	report, err := migrate.Import(ctx, c.client, src, opts)
+	if err != nil {
+		return fmt.Errorf("import failed: %w", err)
+	}
+	bar.finish("import complete")
+	return printMigrateReport(c.formatter, "Imported", report, opts.Journal)
+}
+
+// ExportCommand bulk-downloads files to a directory, tar archive or S3 prefix
+type ExportCommand struct {
+	BaseCommand
+}
+
+// NewExportCommand creates a new export command
+func NewExportCommand(client *client.Client, formatter *formatter.Formatter) *ExportCommand {
+	return &ExportCommand{
+		BaseCommand: BaseCommand{
+			name:        "export",
+			description: "Bulk-export files with their tags and metadata to a directory, tar archive or S3 prefix",
+			usage:       "export <dir|file.tar[.gz]|s3://bucket/prefix> [--tag t] [--prefix p] [--workers N] [--retries N] [--journal path] [--fresh] [--region r] [--endpoint url]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the export command. Tar archives are written in one
+// pass and can't be resumed; directories and S3 prefixes can.
+func (c *ExportCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s", c.usage)
+	}
+	dest, err := migrate.ParseLocation(args[0])
+	if err != nil {
+		return err
+	}
+	opts, filter, err := parseMigrateOptions("export", dest, args[1:], true)
+	if err != nil {
+		return err
+	}
+	if dest.Kind == migrate.KindTar {
+		opts.Journal = ""
+	}
+
+	bar := newMigrateProgress(c.formatter, &opts)
+	report, err := migrate.Export(ctx, c.client, dest, filter, opts)
+	if err != nil {
+		return fmt.Errorf("export failed: %w", err)
+	}
+	bar.finish("export complete")
+	return printMigrateReport(c.formatter, "Exported", report, opts.Journal)
+}
+
+// parseMigrateOptions parses the flags shared by import and export. The
+// journal defaults to a file in the working directory named after the
+// location, so rerunning the same command resumes it.
+func parseMigrateOptions(op string, loc migrate.Location, args []string, export bool) (migrate.Options, migrate.ExportFilter, error) {
+	opts := migrate.Options{Workers: 4, Retries: 2}
+	var filter migrate.ExportFilter
+	fresh := false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--fresh":
+			fresh = true
+		case "--tag", "--workers", "--retries", "--journal", "--region", "--endpoint", "--prefix":
+			if i+1 >= len(args) {
+				return opts, filter, fmt.Errorf("%s requires a value", args[i])
+			}
+			value := args[i+1]
+			switch args[i] {
+			case "--tag":
+				if export {
+					filter.Tags = append(filter.Tags, value)
+				} else {
+					opts.Tags = append(opts.Tags, value)
+				}
+			case "--workers", "--retries":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 || (args[i] == "--workers" && n == 0) {
+					return opts, filter, fmt.Errorf("invalid %s: %s", args[i], value)
+				}
+				if args[i] == "--workers" {
+					opts.Workers = n
+				} else {
+					opts.Retries = n
+				}
+			case "--journal":
+				opts.Journal = value
+			case "--region":
+				opts.S3.Region = value
+			case "--endpoint":
+				opts.S3.Endpoint = value
+			case "--prefix":
+				if !export {
+					return opts, filter, fmt.Errorf("unknown option: %s", args[i])
+				}
+				filter.Prefix = value
+			}
+			i++
+		default:
+			return opts, filter, fmt.Errorf("unknown option: %s", args[i])
+		}
+	}
+
+	if opts.Journal == "" {
+		sum := sha256.Sum256([]byte(loc.String()))
+		opts.Journal = fmt.Sprintf(".peervault-%s-%x.jsonl", op, sum[:4])
+	}
+	if fresh {
+		if err := os.Remove(opts.Journal); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return opts, filter, err
+		}
+	}
+	return opts, filter, nil
+}
+
+// migrateProgress draws a progress bar once the number of files is known
+type migrateProgress struct {
+	bar *formatter.ProgressBar
+}
+
+func newMigrateProgress(f *formatter.Formatter, opts *migrate.Options) *migrateProgress {
+	p := &migrateProgress{}
+	if f.Quiet() {
+		return p
+	}
+	opts.OnProgress = func(pr migrate.Progress) {
+		if p.bar == nil {
+			p.bar = formatter.NewProgressBar(pr.Total)
+		}
+		p.bar.Update(pr.Done+pr.Skipped+pr.Failed, fmt.Sprintf("%s %s", f.FormatBytes(pr.Bytes), pr.Current))
+	}
+	return p
+}
+
+func (p *migrateProgress) finish(message string) {
+	if p.bar != nil {
+		p.bar.Complete(message)
+	}
+}
+
+func printMigrateReport(f *formatter.Formatter, verb string, report *migrate.Report, journal string) error {
+	err := f.PrintResult(report, func() {
+		f.PrintTable([]string{"Field", "Value"}, [][]string{
+			{verb, fmt.Sprintf("%d (%s)", report.Done, f.FormatBytes(report.Bytes))},
+			{"Skipped (already done)", fmt.Sprintf("%d", report.Skipped)},
+			{"Failed", fmt.Sprintf("%d", report.Failed)},
+			{"Elapsed", report.Elapsed.Round(time.Millisecond).String()},
+		})
+	})
+	if err != nil {
+		return err
+	}
+	for _, failure := range report.Failures {
+		f.PrintWarning(fmt.Sprintf("%s: %s", failure.Path, failure.Error))
+	}
+	if report.Failed > 0 {
+		if journal != "" {
+			f.PrintInfo("Run the same command again to retry the failed files; completed ones are recorded in " + journal)
+		}
+		return fmt.Errorf("%d of %d files failed", report.Failed, report.Failed+report.Done+report.Skipped)
+	}
+	if journal != "" {
+		f.PrintInfo("Progress was recorded in " + journal + "; pass --fresh to start over")
+	}
+	return nil
+}
@@ -22,8 +22,11 @@ type LoadBalancerCommand struct {
 func NewLoadBalancerCommand(client *client.Client, formatter *formatter.Formatter, loadBalancer *network.LoadBalancer) *LoadBalancerCommand {
 	return &LoadBalancerCommand{
 		BaseCommand: BaseCommand{
-			client:    client,
-			formatter: formatter,
+			name:        "lb",
+			description: "Load balancer operations",
+			usage:       "lb [add-server|remove-server|list-servers|get-server|select-server|update-health|stats|config|update-config] [args...]",
+			client:      client,
+			formatter:   formatter,
 		},
 		loadBalancer: loadBalancer,
 	}
@@ -324,8 +327,11 @@ type CacheCommand struct {
 func NewCacheCommand(client *client.Client, formatter *formatter.Formatter, cacheManager *network.CacheManager) *CacheCommand {
 	return &CacheCommand{
 		BaseCommand: BaseCommand{
-			client:    client,
-			formatter: formatter,
+			name:        "cache",
+			description: "Cache operations",
+			usage:       "cache [set|get|delete|clear|list|stats|config|update-config|cache-file|get-cached-file] [args...]",
+			client:      client,
+			formatter:   formatter,
 		},
 		cacheManager: cacheManager,
 	}
@@ -0,0 +1,239 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+	"github.com/Skpow1234/Peervault/internal/policy"
+	"github.com/Skpow1234/Peervault/internal/sharing"
+)
+
+// PolicyCommand shows the content policy of the node and tries rules out
+// locally before they are deployed
+type PolicyCommand struct {
+	BaseCommand
+}
+
+// NewPolicyCommand creates a new policy command
+func NewPolicyCommand(client *client.Client, formatter *formatter.Formatter) *PolicyCommand {
+	return &PolicyCommand{
+		BaseCommand: BaseCommand{
+			name:        "policy",
+			description: "Show content policy rules and decisions, or dry-run rules locally",
+			usage:       "policy [show|decisions [--denied] [--limit N]|check <rules.yaml>|eval <rules.yaml> op=store key=... [size=N type=... tenant=... tag=a,b meta.k=v peer.addr=... peer.region=... node.region=... share.ttl=24h source.key=... source.tenant=...]]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the policy command
+func (c *PolicyCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return c.showPolicy(ctx)
+	}
+
+	subcommand := strings.ToLower(args[0])
+	switch subcommand {
+	case "show":
+		return c.showPolicy(ctx)
+	case "decisions", "log":
+		return c.listDecisions(ctx, args[1:])
+	case "check":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: policy check <rules.yaml>")
+		}
+		return c.check(args[1])
+	case "eval", "dry-run":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: policy eval <rules.yaml> op=store key=... [field=value...]")
+		}
+		return c.eval(args[1], args[2:])
+	default:
+		return fmt.Errorf("unknown subcommand: %s", subcommand)
+	}
+}
+
+// showPolicy prints the rules the server evaluates
+func (c *PolicyCommand) showPolicy(ctx context.Context) error {
+	info, err := c.client.GetPolicy(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get policy: %w", err)
+	}
+	return c.formatter.PrintResult(info, func() {
+		if len(info.Rules) == 0 {
+			c.formatter.PrintInfo("No policy rules configured")
+			return
+		}
+		rows := make([][]string, len(info.Rules))
+		for i, r := range info.Rules {
+			ops := "*"
+			if len(r.Operations) > 0 {
+				ops = strings.Join(r.Operations, ",")
+			}
+			rows[i] = []string{r.ID, ops, r.Deny, fmt.Sprintf("%t", !r.Disabled)}
+		}
+		c.formatter.PrintTable([]string{"Rule", "Operations", "Deny When", "Enabled"}, rows)
+		if info.DryRun {
+			c.formatter.PrintWarning("Dry-run mode: denials are logged but not enforced")
+		}
+	})
+}
+
+// listDecisions prints the most recent decisions of the server
+func (c *PolicyCommand) listDecisions(ctx context.Context, args []string) error {
+	limit := 50
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == "--limit" {
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid limit: %s", args[i+1])
+			}
+			limit = n
+		}
+	}
+
+	list, err := c.client.ListPolicyDecisions(ctx, limit, contains(args, "--denied"))
+	if err != nil {
+		return fmt.Errorf("failed to list policy decisions: %w", err)
+	}
+	return c.formatter.PrintResult(list, func() {
+		if len(list.Decisions) == 0 {
+			c.formatter.PrintInfo("No policy decisions recorded")
+			return
+		}
+		rows := make([][]string, len(list.Decisions))
+		for i, d := range list.Decisions {
+			outcome := "allowed"
+			if !d.Allowed {
+				outcome = "denied"
+				if d.DryRun {
+					outcome = "denied (dry run)"
+				}
+			}
+			reasons := make([]string, len(d.Denials))
+			for j, denial := range d.Denials {
+				reasons[j] = denial.Rule + ": " + denial.Message
+			}
+			rows[i] = []string{d.Time.Format("2006-01-02 15:04:05"), d.Operation, d.Key, d.Peer, outcome, strings.Join(reasons, "; ")}
+		}
+		c.formatter.PrintTable([]string{"Time", "Op", "Key", "Peer", "Outcome", "Reasons"}, rows)
+	})
+}
+
+// check loads and compiles a policy file
+func (c *PolicyCommand) check(path string) error {
+	p, err := policy.LoadPolicy(path)
+	if err != nil {
+		return err
+	}
+	c.formatter.PrintSuccess(fmt.Sprintf("%s: %d rule(s) compiled", path, len(p.Rules)))
+	return nil
+}
+
+// eval evaluates a policy file on an operation described by field=value
+// arguments, without contacting the server
+func (c *PolicyCommand) eval(path string, fields []string) error {
+	p, err := policy.LoadPolicy(path)
+	if err != nil {
+		return err
+	}
+	in, err := parsePolicyInput(fields)
+	if err != nil {
+		return err
+	}
+	denials, err := policy.Evaluate(p, in)
+	if err != nil {
+		return err
+	}
+
+	result := struct {
+		Input   policy.Input    `json:"input"`
+		Allowed bool            `json:"allowed"`
+		Denials []policy.Denial `json:"denials,omitempty"`
+	}{in, len(denials) == 0, denials}
+	return c.formatter.PrintResult(result, func() {
+		if len(denials) == 0 {
+			c.formatter.PrintSuccess(fmt.Sprintf("%s %s: allowed", in.Operation, in.Key))
+			return
+		}
+		rows := make([][]string, len(denials))
+		for i, d := range denials {
+			rows[i] = []string{d.Rule, d.Message}
+		}
+		c.formatter.PrintWarning(fmt.Sprintf("%s %s: denied", in.Operation, in.Key))
+		c.formatter.PrintTable([]string{"Rule", "Message"}, rows)
+	})
+}
+
+// parsePolicyInput builds the operation to evaluate from field=value pairs
+func parsePolicyInput(fields []string) (policy.Input, error) {
+	in := policy.Input{Operation: policy.OpStore}
+	for _, field := range fields {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return in, fmt.Errorf("expected field=value, got %q", field)
+		}
+
+		var err error
+		switch name {
+		case "op":
+			in.Operation = policy.Operation(strings.ToLower(value))
+		case "key":
+			in.Key = value
+		case "size":
+			in.Size, err = strconv.ParseInt(value, 10, 64)
+		case "type", "content_type":
+			in.ContentType = value
+		case "tenant":
+			in.Tenant = value
+		case "tag", "tags":
+			in.Tags = append(in.Tags, strings.Split(value, ",")...)
+		case "peer.id":
+			in.Peer.ID = value
+		case "peer.addr":
+			in.Peer.Addr = value
+		case "peer.region":
+			in.Peer.Region = value
+		case "node.id":
+			in.Node.ID = value
+		case "node.addr":
+			in.Node.Addr = value
+		case "node.region":
+			in.Node.Region = value
+		case "share.ttl":
+			var ttl time.Duration
+			if ttl, err = sharing.ParseTTL(value); err == nil {
+				in.Share.TTLSeconds = int64(ttl.Seconds())
+			}
+		case "share.max_downloads":
+			in.Share.MaxDownloads, err = strconv.Atoi(value)
+		case "share.password":
+			in.Share.Password, err = strconv.ParseBool(value)
+		case "share.created_by":
+			in.Share.CreatedBy = value
+		case "source.key":
+			in.Source.Key = value
+		case "source.tenant":
+			in.Source.Tenant = value
+		default:
+			meta, isMeta := strings.CutPrefix(name, "meta.")
+			if !isMeta {
+				return in, fmt.Errorf("unknown field %q", name)
+			}
+			if in.Metadata == nil {
+				in.Metadata = make(map[string]string)
+			}
+			in.Metadata[meta] = value
+		}
+		if err != nil {
+			return in, fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return in, nil
+}
@@ -0,0 +1,286 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/config"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+	"github.com/Skpow1234/Peervault/internal/cli/profiles"
+)
+
+// ProfileCommand manages named server connections
+type ProfileCommand struct {
+	BaseCommand
+	profiles *profiles.Manager
+}
+
+// profileInfo is a profile as printed by list and show
+type profileInfo struct {
+	Name               string `json:"name"`
+	Current            bool   `json:"current"`
+	ServerURL          string `json:"server_url"`
+	Token              string `json:"token"`
+	CACert             string `json:"ca_cert,omitempty"`
+	ClientCert         string `json:"client_cert,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// NewProfileCommand creates a new profile command
+func NewProfileCommand(client *client.Client, formatter *formatter.Formatter, profiles *profiles.Manager) *ProfileCommand {
+	return &ProfileCommand{
+		BaseCommand: BaseCommand{
+			name:        "profile",
+			description: "Manage named server connections",
+			usage:       "profile [list|show [name]|add <name> --url <url> [--token <token|->|--token-file <file>] [--ca-cert <file>] [--client-cert <file> --client-key <file>] [--server-name <name>] [--insecure-skip-verify]|use <name>|remove <name>]",
+			client:      client,
+			formatter:   formatter,
+		},
+		profiles: profiles,
+	}
+}
+
+// Execute executes the profile command
+func (c *ProfileCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return c.list()
+	}
+
+	subcommand := strings.ToLower(args[0])
+	switch subcommand {
+	case "list":
+		return c.list()
+	case "show":
+		name := c.profiles.Current()
+		if len(args) > 1 {
+			name = args[1]
+		}
+		if name == "" {
+			c.formatter.PrintInfo("No profile selected; commands use server_url from the config")
+			return nil
+		}
+		return c.show(name)
+	case "add":
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			return fmt.Errorf("usage: profile add <name> --url <url> [options]")
+		}
+		return c.add(args[1], args[2:])
+	case "use":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: profile use <name>")
+		}
+		return c.use(args[1])
+	case "remove", "rm":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: profile remove <name>")
+		}
+		return c.remove(args[1])
+	default:
+		return fmt.Errorf("unknown subcommand: %s", subcommand)
+	}
+}
+
+// list prints every profile, marking the current one
+func (c *ProfileCommand) list() error {
+	names := c.profiles.Names()
+	infos := make([]profileInfo, 0, len(names))
+	for _, name := range names {
+		info, err := c.info(name)
+		if err != nil {
+			return err
+		}
+		infos = append(infos, info)
+	}
+
+	return c.formatter.PrintResult(infos, func() {
+		if len(infos) == 0 {
+			c.formatter.PrintInfo("No profiles; add one with: profile add <name> --url <url>")
+			return
+		}
+		rows := make([][]string, len(infos))
+		for i, info := range infos {
+			current := ""
+			if info.Current {
+				current = "*"
+			}
+			rows[i] = []string{current, info.Name, info.ServerURL, info.Token}
+		}
+		c.formatter.PrintTable([]string{"Current", "Profile", "Server", "Token"}, rows)
+	})
+}
+
+// show prints one profile
+func (c *ProfileCommand) show(name string) error {
+	info, err := c.info(name)
+	if err != nil {
+		return err
+	}
+
+	return c.formatter.PrintResult(info, func() {
+		rows := [][]string{
+			{"Profile", info.Name},
+			{"Current", fmt.Sprintf("%t", info.Current)},
+			{"Server", info.ServerURL},
+			{"Token", info.Token},
+		}
+		if info.CACert != "" {
+			rows = append(rows, []string{"CA Certificate", info.CACert})
+		}
+		if info.ClientCert != "" {
+			rows = append(rows, []string{"Client Certificate", info.ClientCert})
+		}
+		if info.ServerName != "" {
+			rows = append(rows, []string{"Server Name", info.ServerName})
+		}
+		if info.InsecureSkipVerify {
+			rows = append(rows, []string{"Verify Server", "false"})
+		}
+		c.formatter.PrintTable([]string{"Field", "Value"}, rows)
+	})
+}
+
+func (c *ProfileCommand) info(name string) (profileInfo, error) {
+	profile, ok := c.profiles.Get(name)
+	if !ok {
+		return profileInfo{}, fmt.Errorf("%w: %s", profiles.ErrNotFound, name)
+	}
+	token, err := c.profiles.TokenSource(name)
+	if err != nil {
+		return profileInfo{}, err
+	}
+
+	info := profileInfo{
+		Name:      name,
+		Current:   name == c.profiles.Current(),
+		ServerURL: profile.ServerURL,
+		Token:     token,
+	}
+	if profile.TLS != nil {
+		info.CACert = profile.TLS.CACert
+		info.ClientCert = profile.TLS.ClientCert
+		info.ServerName = profile.TLS.ServerName
+		info.InsecureSkipVerify = profile.TLS.InsecureSkipVerify
+	}
+	return info, nil
+}
+
+// add creates or replaces a profile
+func (c *ProfileCommand) add(name string, args []string) error {
+	profile, token, err := parseProfileOptions(args)
+	if err != nil {
+		return err
+	}
+	if profile.ServerURL == "" {
+		return fmt.Errorf("usage: profile add <name> --url <url> [options]")
+	}
+	if token == "-" {
+		if token, err = readToken(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.profiles.Add(name, profile, token); err != nil {
+		return err
+	}
+	c.formatter.PrintSuccess(fmt.Sprintf("Saved profile %s (%s)", name, profile.ServerURL))
+	if token != "" {
+		c.formatter.PrintInfo(fmt.Sprintf("Token stored in %s", c.profiles.StoreName()))
+	}
+	if profile.TLS != nil && profile.TLS.InsecureSkipVerify {
+		c.formatter.PrintWarning("Server certificates are not verified for this profile")
+	}
+	if c.profiles.Current() == name {
+		c.formatter.PrintInfo(fmt.Sprintf("Commands now connect with profile %s", name))
+	}
+	return nil
+}
+
+// use makes a profile the default and switches this session to it
+func (c *ProfileCommand) use(name string) error {
+	if err := c.profiles.Use(name); err != nil {
+		return err
+	}
+	conn, err := c.profiles.Resolve(name)
+	if err != nil {
+		return err
+	}
+	if err := c.client.UseConnection(conn.ServerURL, conn.Token, conn.TLS); err != nil {
+		return fmt.Errorf("profile %s: %w", name, err)
+	}
+	c.formatter.PrintSuccess(fmt.Sprintf("Using profile %s (%s)", name, conn.ServerURL))
+	return nil
+}
+
+// remove deletes a profile and its stored token
+func (c *ProfileCommand) remove(name string) error {
+	current := c.profiles.Current() == name
+	if err := c.profiles.Remove(name); err != nil {
+		return err
+	}
+	c.formatter.PrintSuccess(fmt.Sprintf("Removed profile %s", name))
+	if current {
+		c.formatter.PrintInfo("No profile selected; commands use server_url from the config. Pick one with: profile use <name>")
+	}
+	return nil
+}
+
+// parseProfileOptions parses the options of profile add
+func parseProfileOptions(args []string) (*config.Profile, string, error) {
+	profile := &config.Profile{}
+	tls := &config.TLSConfig{}
+	var token string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--insecure-skip-verify":
+			tls.InsecureSkipVerify = true
+		case "--url", "--token", "--token-file", "--ca-cert", "--client-cert", "--client-key", "--server-name":
+			if i+1 >= len(args) {
+				return nil, "", fmt.Errorf("%s requires a value", args[i])
+			}
+			value := args[i+1]
+			switch args[i] {
+			case "--url":
+				profile.ServerURL = value
+			case "--token":
+				token = value
+			case "--token-file":
+				profile.TokenFile = value
+			case "--ca-cert":
+				tls.CACert = value
+			case "--client-cert":
+				tls.ClientCert = value
+			case "--client-key":
+				tls.ClientKey = value
+			case "--server-name":
+				tls.ServerName = value
+			}
+			i++
+		default:
+			return nil, "", fmt.Errorf("unknown option: %s", args[i])
+		}
+	}
+
+	if *tls != (config.TLSConfig{}) {
+		profile.TLS = tls
+	}
+	return profile, token, nil
+}
+
+// readToken reads a token from the first line of stdin, so it stays out of
+// the shell history
+func readToken() (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	token := strings.TrimSpace(line)
+	if token == "" {
+		if err != nil {
+			return "", fmt.Errorf("failed to read token from stdin: %w", err)
+		}
+		return "", fmt.Errorf("no token on stdin")
+	}
+	return token, nil
+}
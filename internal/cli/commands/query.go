@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// QueryCommand runs read-only SQL queries over the metadata of the node
+type QueryCommand struct {
+	BaseCommand
+}
+
+// NewQueryCommand creates a new query command
+func NewQueryCommand(client *client.Client, formatter *formatter.Formatter) *QueryCommand {
+	return &QueryCommand{
+		BaseCommand: BaseCommand{
+			name:        "query",
+			description: "Run a read-only SQL query over file metadata, or list the tables",
+			usage:       "query [--max-rows <n>] <select statement> | query tables",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the query command
+func (c *QueryCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s", c.usage)
+	}
+	if len(args) == 1 && strings.EqualFold(args[0], "tables") {
+		return c.listTables(ctx)
+	}
+
+	maxRows := 0
+	var words []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--max-rows" && i+1 < len(args) {
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid max rows: %s", args[i+1])
+			}
+			maxRows = n
+			i++
+			continue
+		}
+		words = append(words, args[i])
+	}
+	// The statement may be quoted as one argument or left as several
+	sql := strings.Join(words, " ")
+
+	result, err := c.client.Query(ctx, sql, maxRows)
+	if err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+	return c.formatter.PrintResult(result, func() {
+		rows := make([][]string, len(result.Rows))
+		for i, row := range result.Rows {
+			rows[i] = make([]string, len(row))
+			for j, v := range row {
+				rows[i][j] = formatCell(v)
+			}
+		}
+		c.formatter.PrintTable(result.Columns, rows)
+		if result.Truncated {
+			c.formatter.PrintWarning(fmt.Sprintf("Showing the first %d rows, add LIMIT or --max-rows to see others", result.Total))
+		} else {
+			c.formatter.PrintInfo(fmt.Sprintf("%d row(s)", result.Total))
+		}
+	})
+}
+
+// listTables prints the tables queries can select from
+func (c *QueryCommand) listTables(ctx context.Context) error {
+	list, err := c.client.ListQueryTables(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list query tables: %w", err)
+	}
+	return c.formatter.PrintResult(list, func() {
+		rows := make([][]string, len(list.Tables))
+		for i, t := range list.Tables {
+			rows[i] = []string{t.Name, strings.Join(t.Columns, ", "), t.Description}
+		}
+		c.formatter.PrintTable([]string{"Table", "Columns", "Description"}, rows)
+	})
+}
+
+// formatCell prints a value of a query row as decoded from JSON
+func formatCell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
@@ -0,0 +1,183 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// QueueCommand inspects the background job queue of the server and
+// requeues or drops its dead letters
+type QueueCommand struct {
+	BaseCommand
+}
+
+// NewQueueCommand creates a new queue command
+func NewQueueCommand(client *client.Client, formatter *formatter.Formatter) *QueueCommand {
+	return &QueueCommand{
+		BaseCommand: BaseCommand{
+			name:        "queue",
+			description: "Inspect the server's background jobs (replication, re-encryption, thumbnails, webhooks) and their dead letters",
+			usage:       "queue [stats|list [pending|running|dead] [kind]|dead [kind]|show <id>|requeue <id>|requeue-dead [kind]|delete <id>]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the queue command
+func (c *QueueCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return c.stats(ctx)
+	}
+
+	subcommand := strings.ToLower(args[0])
+	switch subcommand {
+	case "stats":
+		return c.stats(ctx)
+	case "list", "ls":
+		state, kind := "", ""
+		if len(args) > 1 {
+			state = args[1]
+		}
+		if len(args) > 2 {
+			kind = args[2]
+		}
+		return c.list(ctx, state, kind)
+	case "dead":
+		kind := ""
+		if len(args) > 1 {
+			kind = args[1]
+		}
+		return c.list(ctx, "dead", kind)
+	case "show", "get":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: queue show <id>")
+		}
+		return c.show(ctx, args[1])
+	case "requeue":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: queue requeue <id>")
+		}
+		return c.requeue(ctx, args[1])
+	case "requeue-dead":
+		kind := ""
+		if len(args) > 1 {
+			kind = args[1]
+		}
+		return c.requeueDead(ctx, kind)
+	case "delete", "rm":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: queue delete <id>")
+		}
+		return c.delete(ctx, args[1])
+	default:
+		return fmt.Errorf("unknown subcommand: %s", subcommand)
+	}
+}
+
+func (c *QueueCommand) stats(ctx context.Context) error {
+	stats, err := c.client.GetQueueStats(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get queue stats: %w", err)
+	}
+	return c.formatter.PrintResult(stats, func() {
+		rows := [][]string{
+			{"Pending", fmt.Sprintf("%d", stats.Pending)},
+			{"Running", fmt.Sprintf("%d", stats.Running)},
+			{"Dead", fmt.Sprintf("%d", stats.Dead)},
+			{"Completed", fmt.Sprintf("%d", stats.Completed)},
+			{"Failed Attempts", fmt.Sprintf("%d", stats.Retried)},
+		}
+		kinds := make([]string, 0, len(stats.Kinds))
+		for kind := range stats.Kinds {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			rows = append(rows, []string{"Queued " + kind, fmt.Sprintf("%d", stats.Kinds[kind])})
+		}
+		c.formatter.PrintTable([]string{"Field", "Value"}, rows)
+	})
+}
+
+func (c *QueueCommand) list(ctx context.Context, state, kind string) error {
+	list, err := c.client.ListQueuedJobs(ctx, state, kind)
+	if err != nil {
+		return fmt.Errorf("failed to list queued jobs: %w", err)
+	}
+	return c.formatter.PrintResult(list, func() {
+		if len(list.Jobs) == 0 {
+			c.formatter.PrintInfo("No jobs queued")
+			return
+		}
+		rows := make([][]string, len(list.Jobs))
+		for i, job := range list.Jobs {
+			when := formatTime(job.RunAt)
+			if job.State == "dead" {
+				when = formatTime(job.DeadAt)
+			}
+			rows[i] = []string{job.ID, job.Kind, job.State, fmt.Sprintf("%d/%d", job.Attempts, job.MaxAttempts), when, orDash(job.LastError)}
+		}
+		c.formatter.PrintTable([]string{"ID", "Kind", "State", "Attempts", "Next Run / Died", "Last Error"}, rows)
+	})
+}
+
+func (c *QueueCommand) show(ctx context.Context, id string) error {
+	job, err := c.client.GetQueuedJob(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to get queued job: %w", err)
+	}
+	return c.formatter.PrintResult(job, func() {
+		c.printJob(job)
+	})
+}
+
+func (c *QueueCommand) requeue(ctx context.Context, id string) error {
+	job, err := c.client.RequeueJob(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to requeue job: %w", err)
+	}
+	return c.formatter.PrintResult(job, func() {
+		c.formatter.PrintSuccess(fmt.Sprintf("Job %s requeued", id))
+		c.printJob(job)
+	})
+}
+
+func (c *QueueCommand) requeueDead(ctx context.Context, kind string) error {
+	n, err := c.client.RequeueDeadJobs(ctx, kind)
+	if err != nil {
+		return fmt.Errorf("failed to requeue dead jobs: %w", err)
+	}
+	return c.formatter.PrintResult(map[string]int{"requeued": n}, func() {
+		c.formatter.PrintSuccess(fmt.Sprintf("%d dead jobs requeued", n))
+	})
+}
+
+func (c *QueueCommand) delete(ctx context.Context, id string) error {
+	if err := c.client.DeleteQueuedJob(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete queued job: %w", err)
+	}
+	c.formatter.PrintSuccess(fmt.Sprintf("Job %s deleted", id))
+	return nil
+}
+
+func (c *QueueCommand) printJob(job *client.QueuedJob) {
+	rows := [][]string{
+		{"ID", job.ID},
+		{"Kind", job.Kind},
+		{"State", job.State},
+		{"Priority", fmt.Sprintf("%d", job.Priority)},
+		{"Attempts", fmt.Sprintf("%d/%d", job.Attempts, job.MaxAttempts)},
+		{"Created", formatTime(job.CreatedAt)},
+		{"Next Run", formatTime(job.RunAt)},
+		{"Died", formatTime(job.DeadAt)},
+		{"Last Error", orDash(job.LastError)},
+		{"Payload", orDash(string(job.Payload))},
+	}
+	c.formatter.PrintTable([]string{"Field", "Value"}, rows)
+}
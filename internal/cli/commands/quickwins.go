@@ -8,12 +8,9 @@ import (
 	"github.com/Skpow1234/Peervault/internal/cli"
 	"github.com/Skpow1234/Peervault/internal/cli/aliases"
 	"github.com/Skpow1234/Peervault/internal/cli/client"
-	"github.com/Skpow1234/Peervault/internal/cli/config"
 	"github.com/Skpow1234/Peervault/internal/cli/formatter"
 	"github.com/Skpow1234/Peervault/internal/cli/help"
 	"github.com/Skpow1234/Peervault/internal/cli/macros"
-	"github.com/Skpow1234/Peervault/internal/cli/output"
-	"github.com/Skpow1234/Peervault/internal/cli/profiles"
 )
 
 // EnhancedHelpCommand provides enhanced help with examples and tutorials
@@ -168,8 +165,6 @@ func (c *AliasCommand) listAliases() error {
 // FormatCommand manages output formatting
 type FormatCommand struct {
 	BaseCommand
-	outputFormatter *output.Formatter
-	formatter       *formatter.Formatter
 }
 
 // NewFormatCommand creates a new format command
@@ -178,12 +173,10 @@ func NewFormatCommand(client *client.Client, formatter *formatter.Formatter) *Fo
 		BaseCommand: BaseCommand{
 			name:        "format",
 			description: "Set output format for commands",
-			usage:       "format [set|get|list] [format]",
+			usage:       "format [set <table|json|yaml|csv|go-template=<template>>|get|list]",
 			client:      client,
 			formatter:   formatter,
 		},
-		outputFormatter: output.New(output.FormatTable),
-		formatter:       formatter,
 	}
 }
 
@@ -206,214 +199,34 @@ func (c *FormatCommand) Execute(ctx context.Context, args []string) error {
 	}
 }
 
-// setFormat sets the output format
+// setFormat sets the output format for the rest of the session
 func (c *FormatCommand) setFormat(args []string) error {
 	if len(args) < 1 {
 		return fmt.Errorf("usage: format set <format>")
 	}
 
-	formatStr := strings.ToLower(args[0])
-	format := output.Format(formatStr)
-
-	// Validate format
-	validFormats := []output.Format{output.FormatTable, output.FormatJSON, output.FormatYAML, output.FormatCSV, output.FormatText}
-	valid := false
-	for _, f := range validFormats {
-		if format == f {
-			valid = true
-			break
-		}
+	spec := strings.Join(args, " ")
+	if err := c.formatter.SetFormat(spec); err != nil {
+		return fmt.Errorf("usage: format set <format>: %w", err)
 	}
-
-	if !valid {
-		return fmt.Errorf("invalid format: %s. Valid formats: table, json, yaml, csv, text", formatStr)
-	}
-
-	c.outputFormatter.SetFormat(format)
-	c.formatter.PrintSuccess(fmt.Sprintf("Output format set to: %s", format))
+	c.formatter.PrintSuccess(fmt.Sprintf("Output format set to: %s", c.formatter.Format()))
 	return nil
 }
 
 // showCurrentFormat shows the current format
 func (c *FormatCommand) showCurrentFormat() error {
-	format := c.outputFormatter.GetFormat()
-	c.formatter.PrintInfo(fmt.Sprintf("Current output format: %s", format))
+	c.formatter.PrintInfo(fmt.Sprintf("Current output format: %s", c.formatter.Format()))
 	return nil
 }
 
 // listFormats lists all available formats
 func (c *FormatCommand) listFormats() error {
 	c.formatter.PrintInfo("Available output formats:")
-	fmt.Println("  table  - Human-readable table format (default)")
-	fmt.Println("  json   - JSON format for scripting")
-	fmt.Println("  yaml   - YAML format for configuration")
-	fmt.Println("  csv    - CSV format for spreadsheets")
-	fmt.Println("  text   - Plain text format")
-	return nil
-}
-
-// ProfileCommand manages configuration profiles
-type ProfileCommand struct {
-	BaseCommand
-	profileManager *profiles.Manager
-	formatter      *formatter.Formatter
-}
-
-// NewProfileCommand creates a new profile command
-func NewProfileCommand(client *client.Client, formatter *formatter.Formatter) *ProfileCommand {
-	return &ProfileCommand{
-		BaseCommand: BaseCommand{
-			name:        "profile",
-			description: "Manage configuration profiles",
-			usage:       "profile [create|switch|list|delete|clone] [options]",
-			client:      client,
-			formatter:   formatter,
-		},
-		profileManager: profiles.New("./config"),
-		formatter:      formatter,
-	}
-}
-
-// Execute executes the profile command
-func (c *ProfileCommand) Execute(ctx context.Context, args []string) error {
-	if len(args) == 0 {
-		return c.showCurrentProfile()
-	}
-
-	subcommand := strings.ToLower(args[0])
-	switch subcommand {
-	case "create":
-		return c.createProfile(args[1:])
-	case "switch":
-		return c.switchProfile(args[1:])
-	case "list":
-		return c.listProfiles()
-	case "delete":
-		return c.deleteProfile(args[1:])
-	case "clone":
-		return c.cloneProfile(args[1:])
-	case "current":
-		return c.showCurrentProfile()
-	default:
-		return fmt.Errorf("unknown subcommand: %s", subcommand)
-	}
-}
-
-// createProfile creates a new profile
-func (c *ProfileCommand) createProfile(args []string) error {
-	if len(args) < 2 {
-		return fmt.Errorf("usage: profile create <name> <description>")
-	}
-
-	name := args[0]
-	description := strings.Join(args[1:], " ")
-
-	// Use current config as base
-	baseConfig := &config.Config{
-		ServerURL:    "http://localhost:8080",
-		AuthToken:    "",
-		OutputFormat: "table",
-		Theme:        "default",
-		Verbose:      false,
-		HistoryFile:  "history.txt",
-	}
-
-	err := c.profileManager.CreateProfile(name, description, baseConfig)
-	if err != nil {
-		return fmt.Errorf("failed to create profile: %w", err)
-	}
-
-	c.formatter.PrintSuccess(fmt.Sprintf("Created profile: %s", name))
-	return nil
-}
-
-// switchProfile switches to a different profile
-func (c *ProfileCommand) switchProfile(args []string) error {
-	if len(args) < 1 {
-		return fmt.Errorf("usage: profile switch <name>")
-	}
-
-	name := args[0]
-
-	err := c.profileManager.SwitchProfile(name)
-	if err != nil {
-		return fmt.Errorf("failed to switch profile: %w", err)
-	}
-
-	c.formatter.PrintSuccess(fmt.Sprintf("Switched to profile: %s", name))
-	return nil
-}
-
-// listProfiles lists all profiles
-func (c *ProfileCommand) listProfiles() error {
-	profiles := c.profileManager.ListProfiles()
-	current := c.profileManager.GetCurrentProfileName()
-
-	c.formatter.PrintInfo("Configuration Profiles:")
-	fmt.Println(strings.Repeat("=", 40))
-
-	for name, profile := range profiles {
-		marker := " "
-		if name == current {
-			marker = "*"
-		}
-		fmt.Printf("%s %-15s - %s\n", marker, name, profile.Description)
-	}
-
-	fmt.Printf("\n* = current profile\n")
-	return nil
-}
-
-// deleteProfile deletes a profile
-func (c *ProfileCommand) deleteProfile(args []string) error {
-	if len(args) < 1 {
-		return fmt.Errorf("usage: profile delete <name>")
-	}
-
-	name := args[0]
-
-	err := c.profileManager.DeleteProfile(name)
-	if err != nil {
-		return fmt.Errorf("failed to delete profile: %w", err)
-	}
-
-	c.formatter.PrintSuccess(fmt.Sprintf("Deleted profile: %s", name))
-	return nil
-}
-
-// cloneProfile clones an existing profile
-func (c *ProfileCommand) cloneProfile(args []string) error {
-	if len(args) < 3 {
-		return fmt.Errorf("usage: profile clone <source> <new_name> <description>")
-	}
-
-	source := args[0]
-	newName := args[1]
-	description := strings.Join(args[2:], " ")
-
-	err := c.profileManager.CloneProfile(source, newName, description)
-	if err != nil {
-		return fmt.Errorf("failed to clone profile: %w", err)
-	}
-
-	c.formatter.PrintSuccess(fmt.Sprintf("Cloned profile '%s' to '%s'", source, newName))
-	return nil
-}
-
-// showCurrentProfile shows the current profile
-func (c *ProfileCommand) showCurrentProfile() error {
-	profile := c.profileManager.GetCurrentProfile()
-	if profile == nil {
-		c.formatter.PrintError(fmt.Errorf("no current profile"))
-		return nil
-	}
-
-	c.formatter.PrintInfo(fmt.Sprintf("Current Profile: %s", profile.Name))
-	fmt.Printf("Description: %s\n", profile.Description)
-	fmt.Printf("Server URL: %s\n", profile.Config.ServerURL)
-	fmt.Printf("Output Format: %s\n", profile.Config.OutputFormat)
-	fmt.Printf("Theme: %s\n", profile.Config.Theme)
-	fmt.Printf("Verbose: %v\n", profile.Config.Verbose)
+	fmt.Println("  table                   - Human-readable table format (default)")
+	fmt.Println("  json                    - JSON format for scripting")
+	fmt.Println("  yaml                    - YAML format for configuration")
+	fmt.Println("  csv                     - CSV format for spreadsheets")
+	fmt.Println("  go-template=<template>  - Go text/template over the result, e.g. '{{range .Files}}{{.Key}}{{\"\\n\"}}{{end}}'")
 	return nil
 }
 
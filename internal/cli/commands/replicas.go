@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// ReplicasCommand shows which peers hold a copy of a file
+type ReplicasCommand struct {
+	BaseCommand
+}
+
+// NewReplicasCommand creates a new replicas command
+func NewReplicasCommand(client *client.Client, formatter *formatter.Formatter) *ReplicasCommand {
+	return &ReplicasCommand{
+		BaseCommand: BaseCommand{
+			name:        "replicas",
+			description: "Show which peers hold a copy of a file",
+			usage:       "replicas <file_id>",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the replicas command
+func (c *ReplicasCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: replicas <file_id>")
+	}
+
+	report, err := c.client.GetFileReplicas(ctx, args[0])
+	if err != nil {
+		return fmt.Errorf("failed to get replicas: %w", err)
+	}
+
+	return c.formatter.PrintResult(report, func() {
+		c.formatter.PrintHeader(fmt.Sprintf("Replicas of %s", report.Key))
+		summary := fmt.Sprintf("%d of %d healthy copies", report.Healthy, report.ReplicationFactor)
+		if report.Satisfied {
+			c.formatter.PrintSuccess(summary)
+		} else {
+			c.formatter.PrintWarning(summary + ": replication factor not met")
+		}
+		if len(report.Replicas) == 0 {
+			c.formatter.PrintInfo("No node holds a copy")
+			return
+		}
+
+		rows := make([][]string, len(report.Replicas))
+		for i, r := range report.Replicas {
+			node := r.Node
+			if r.Local {
+				node += " (this node)"
+			}
+			rows[i] = []string{node, orDash(r.Addr), r.Status, c.formatter.FormatBytes(r.Size), formatTime(r.LastVerified)}
+		}
+		c.formatter.PrintTable([]string{"Node", "Address", "Status", "Size", "Last Verified"}, rows)
+	})
+}
@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// ReplicationCommand inspects geo-replication between clusters
+type ReplicationCommand struct {
+	BaseCommand
+}
+
+// NewReplicationCommand creates a new replication command
+func NewReplicationCommand(client *client.Client, formatter *formatter.Formatter) *ReplicationCommand {
+	return &ReplicationCommand{
+		BaseCommand: BaseCommand{
+			name:        "replication",
+			description: "Show geo-replication progress and lag between clusters",
+			usage:       "replication [status]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the replication command
+func (c *ReplicationCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return c.showStatus(ctx)
+	}
+
+	subcommand := strings.ToLower(args[0])
+	switch subcommand {
+	case "status":
+		return c.showStatus(ctx)
+	default:
+		return fmt.Errorf("unknown subcommand: %s", subcommand)
+	}
+}
+
+// showStatus prints outbound targets and inbound sources
+func (c *ReplicationCommand) showStatus(ctx context.Context) error {
+	status, err := c.client.GetReplicationStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get replication status: %w", err)
+	}
+
+	return c.formatter.PrintResult(status, func() {
+		c.formatter.PrintHeader(fmt.Sprintf("Cluster %s (%s)", status.ClusterID, status.Policy))
+
+		if len(status.Targets) == 0 {
+			c.formatter.PrintInfo("No replication targets configured")
+		} else {
+			rows := make([][]string, len(status.Targets))
+			for i, t := range status.Targets {
+				state := "connected"
+				if !t.Connected {
+					state = "disconnected"
+				}
+				lag := fmt.Sprintf("%d entries", t.LagEntries)
+				if t.LagSeconds > 0 {
+					lag += fmt.Sprintf(" / %s", time.Duration(t.LagSeconds*float64(time.Second)).Round(time.Second))
+				}
+				rows[i] = []string{t.Name, t.URL, state, fmt.Sprintf("%d/%d", t.AckedIndex, t.SourceIndex), lag, formatTime(t.LastSync), orDash(t.LastError)}
+			}
+			c.formatter.PrintTable([]string{"Target", "URL", "State", "Acked", "Lag", "Last Sync", "Last Error"}, rows)
+		}
+
+		if len(status.Sources) > 0 {
+			rows := make([][]string, len(status.Sources))
+			for i, s := range status.Sources {
+				rows[i] = []string{
+					s.Cluster,
+					fmt.Sprintf("%d", s.Index),
+					fmt.Sprintf("%d", s.Applied),
+					fmt.Sprintf("%d", s.Conflicts),
+					fmt.Sprintf("%d", s.Failed),
+					formatTime(s.LastBatch),
+					orDash(s.LastError),
+				}
+			}
+			c.formatter.PrintTable([]string{"Source", "Index", "Applied", "Conflicts", "Failed", "Last Batch", "Last Error"}, rows)
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// SearchCommand runs full-text queries and manages the search index
+type SearchCommand struct {
+	BaseCommand
+}
+
+// NewSearchCommand creates a new search command
+func NewSearchCommand(client *client.Client, formatter *formatter.Formatter) *SearchCommand {
+	return &SearchCommand{
+		BaseCommand: BaseCommand{
+			name:        "search",
+			description: "Full-text search over stored documents",
+			usage:       "search <query> [--limit N] [--offset N] [--no-highlight] | search status | search rebuild",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the search command
+func (c *SearchCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s", c.usage)
+	}
+
+	if len(args) == 1 {
+		switch strings.ToLower(args[0]) {
+		case "status":
+			return c.showStatus(ctx)
+		case "rebuild":
+			return c.rebuild(ctx)
+		}
+	}
+
+	var terms []string
+	limit, offset, highlight := 0, 0, true
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--limit", "--offset":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a value", args[i])
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid %s value: %s", args[i], args[i+1])
+			}
+			if args[i] == "--limit" {
+				limit = n
+			} else {
+				offset = n
+			}
+			i++
+		case "--no-highlight":
+			highlight = false
+		default:
+			terms = append(terms, args[i])
+		}
+	}
+	if len(terms) == 0 {
+		return fmt.Errorf("usage: %s", c.usage)
+	}
+
+	results, err := c.client.SearchDocuments(ctx, strings.Join(terms, " "), limit, offset, highlight)
+	if err != nil {
+		return fmt.Errorf("search failed: %w", err)
+	}
+	return c.formatter.PrintSearchResults(results)
+}
+
+// showStatus prints search index statistics
+func (c *SearchCommand) showStatus(ctx context.Context) error {
+	status, err := c.client.GetSearchStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get search status: %w", err)
+	}
+	return c.printStatus(status)
+}
+
+// rebuild starts an index rebuild on the server
+func (c *SearchCommand) rebuild(ctx context.Context) error {
+	status, err := c.client.RebuildSearchIndex(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to rebuild search index: %w", err)
+	}
+	c.formatter.PrintSuccess("Search index rebuild started")
+	return c.printStatus(status)
+}
+
+func (c *SearchCommand) printStatus(status *client.SearchIndexStatus) error {
+	return c.formatter.PrintResult(status, func() {
+		rows := [][]string{
+			{"Documents", fmt.Sprintf("%d", status.Documents)},
+			{"Terms", fmt.Sprintf("%d", status.Terms)},
+			{"Rebuilding", fmt.Sprintf("%t", status.Rebuilding)},
+			{"Persistent", fmt.Sprintf("%t", status.Persistent)},
+		}
+		if status.LastRebuild != nil {
+			rows = append(rows, []string{"Last Rebuild", status.LastRebuild.Format("2006-01-02 15:04:05")})
+		}
+		if status.LastError != "" {
+			rows = append(rows, []string{"Last Error", status.LastError})
+		}
+		c.formatter.PrintTable([]string{"Field", "Value"}, rows)
+	})
+}
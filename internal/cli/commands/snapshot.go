@@ -0,0 +1,258 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+)
+
+// SnapshotCommand manages point-in-time snapshots of namespaces
+type SnapshotCommand struct {
+	BaseCommand
+}
+
+// NewSnapshotCommand creates a new snapshot command
+func NewSnapshotCommand(client *client.Client, formatter *formatter.Formatter) *SnapshotCommand {
+	return &SnapshotCommand{
+		BaseCommand: BaseCommand{
+			name:        "snapshot",
+			description: "Take, browse, diff and clone snapshots of namespaces",
+			usage:       "snapshot [list|create <namespace> [name]|show <id>|get <id> <key> <output>|diff <id> [to]|clone <id> <prefix> [--overwrite]|backup <id> <job> [--full]|delete <id>]",
+			client:      client,
+			formatter:   formatter,
+		},
+	}
+}
+
+// Execute executes the snapshot command
+func (c *SnapshotCommand) Execute(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return c.list(ctx)
+	}
+
+	subcommand := strings.ToLower(args[0])
+	switch subcommand {
+	case "list", "ls":
+		return c.list(ctx)
+	case "create", "take":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: snapshot create <namespace> [name]")
+		}
+		name := ""
+		if len(args) > 2 {
+			name = args[2]
+		}
+		return c.create(ctx, args[1], name)
+	case "show":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: snapshot show <id>")
+		}
+		return c.show(ctx, args[1])
+	case "get", "download":
+		if len(args) < 4 {
+			return fmt.Errorf("usage: snapshot get <id> <key> <output>")
+		}
+		return c.get(ctx, args[1], args[2], args[3])
+	case "diff":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: snapshot diff <id> [to]")
+		}
+		to := ""
+		if len(args) > 2 {
+			to = args[2]
+		}
+		return c.diff(ctx, args[1], to)
+	case "clone":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: snapshot clone <id> <prefix> [--overwrite]")
+		}
+		return c.clone(ctx, args[1], args[2], contains(args[3:], "--overwrite"))
+	case "backup":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: snapshot backup <id> <job> [--full]")
+		}
+		return c.backup(ctx, args[1], args[2], contains(args[3:], "--full"))
+	case "delete":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: snapshot delete <id>")
+		}
+		return c.delete(ctx, args[1])
+	default:
+		return fmt.Errorf("unknown subcommand: %s", subcommand)
+	}
+}
+
+// list prints the snapshots, oldest first
+func (c *SnapshotCommand) list(ctx context.Context) error {
+	snaps, err := c.client.ListSnapshots(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	return c.formatter.PrintResult(snaps, func() {
+		if len(snaps.Snapshots) == 0 {
+			c.formatter.PrintInfo("No snapshots yet; take one with: snapshot create <namespace>")
+			return
+		}
+
+		rows := make([][]string, len(snaps.Snapshots))
+		for i, s := range snaps.Snapshots {
+			rows[i] = []string{
+				s.ID,
+				orDash(s.Name),
+				orDash(s.Namespace),
+				formatTime(s.CreatedAt),
+				fmt.Sprintf("%d", s.Files),
+				c.formatter.FormatBytes(s.Size),
+			}
+		}
+		c.formatter.PrintTable([]string{"Snapshot", "Name", "Namespace", "Created", "Files", "Size"}, rows)
+	})
+}
+
+// create takes a snapshot of a namespace
+func (c *SnapshotCommand) create(ctx context.Context, namespace, name string) error {
+	snap, err := c.client.CreateSnapshot(ctx, namespace, name)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	return c.formatter.PrintResult(snap, func() {
+		c.formatter.PrintSuccess(fmt.Sprintf("Created snapshot %s of %s: %d file(s), %s",
+			snap.ID, orDash(snap.Namespace), snap.Files, c.formatter.FormatBytes(snap.Size)))
+	})
+}
+
+// show prints a snapshot and the files it records
+func (c *SnapshotCommand) show(ctx context.Context, id string) error {
+	snap, err := c.client.GetSnapshot(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to get snapshot: %w", err)
+	}
+
+	return c.formatter.PrintResult(snap, func() {
+		c.formatter.PrintHeader(fmt.Sprintf("Snapshot %s", snap.ID))
+		c.formatter.PrintTable([]string{"Field", "Value"}, [][]string{
+			{"Name", orDash(snap.Name)},
+			{"Namespace", orDash(snap.Namespace)},
+			{"Schedule", orDash(snap.Schedule)},
+			{"Created", formatTime(snap.CreatedAt)},
+			{"Files", fmt.Sprintf("%d (%s)", snap.Files, c.formatter.FormatBytes(snap.Size))},
+		})
+
+		if len(snap.Entries) > 0 {
+			rows := make([][]string, len(snap.Entries))
+			for i, e := range snap.Entries {
+				rows[i] = []string{e.Key, e.Name, c.formatter.FormatBytes(e.Size), formatTime(e.ModTime)}
+			}
+			c.formatter.PrintTable([]string{"Key", "Name", "Size", "Modified"}, rows)
+		}
+	})
+}
+
+// get downloads a file as the snapshot recorded it
+func (c *SnapshotCommand) get(ctx context.Context, id, key, output string) error {
+	f, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	n, err := c.client.DownloadSnapshotContent(ctx, id, key, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(output)
+		return err
+	}
+	c.formatter.PrintSuccess(fmt.Sprintf("Saved %s from snapshot %s to %s (%s)", key, id, output, c.formatter.FormatBytes(n)))
+	return nil
+}
+
+// diff prints what changed between a snapshot and another one, or the live
+// files when to is empty
+func (c *SnapshotCommand) diff(ctx context.Context, id, to string) error {
+	diff, err := c.client.DiffSnapshot(ctx, id, to)
+	if err != nil {
+		return fmt.Errorf("failed to diff snapshot: %w", err)
+	}
+
+	return c.formatter.PrintResult(diff, func() {
+		if len(diff.Changes) == 0 {
+			c.formatter.PrintInfo(fmt.Sprintf("No changes between %s and %s", diff.From, diff.To))
+			return
+		}
+
+		rows := make([][]string, len(diff.Changes))
+		for i, ch := range diff.Changes {
+			from, to := "-", "-"
+			if ch.From != nil {
+				from = c.formatter.FormatBytes(ch.From.Size)
+			}
+			if ch.To != nil {
+				to = c.formatter.FormatBytes(ch.To.Size)
+			}
+			rows[i] = []string{ch.Type, ch.Key, from, to}
+		}
+		c.formatter.PrintTable([]string{"Change", "Key", diff.From, diff.To}, rows)
+		c.formatter.PrintInfo(fmt.Sprintf("%d added, %d removed, %d modified", diff.Added, diff.Removed, diff.Modified))
+	})
+}
+
+// clone writes the files of a snapshot under a new prefix; cloning onto the
+// snapshot's own namespace with --overwrite rolls it back
+func (c *SnapshotCommand) clone(ctx context.Context, id, prefix string, overwrite bool) error {
+	report, err := c.client.CloneSnapshot(ctx, id, prefix, overwrite)
+	if err != nil {
+		return fmt.Errorf("clone failed: %w", err)
+	}
+
+	err = c.formatter.PrintResult(report, func() {
+		c.formatter.PrintTable([]string{"Field", "Value"}, [][]string{
+			{"Snapshot", report.Snapshot},
+			{"Prefix", orDash(report.Prefix)},
+			{"Cloned", fmt.Sprintf("%d (%s)", report.Cloned, c.formatter.FormatBytes(report.Bytes))},
+			{"Skipped (exists)", fmt.Sprintf("%d", report.Skipped)},
+			{"Failed", fmt.Sprintf("%d", report.Failed)},
+		})
+	})
+	if err != nil {
+		return err
+	}
+	for _, msg := range report.Errors {
+		c.formatter.PrintWarning(msg)
+	}
+	if report.Skipped > 0 && !overwrite {
+		c.formatter.PrintInfo("Existing files were kept; re-run with --overwrite to replace them")
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d file(s) could not be cloned", report.Failed)
+	}
+	return nil
+}
+
+// backup runs a backup job from a snapshot instead of the live files
+func (c *SnapshotCommand) backup(ctx context.Context, id, job string, full bool) error {
+	backupType := "incremental"
+	if full {
+		backupType = "full"
+	}
+	snap, err := c.client.BackupSnapshot(ctx, id, job, backupType)
+	if err != nil {
+		return fmt.Errorf("backup failed: %w", err)
+	}
+	return c.formatter.PrintResult(snap, func() {
+		c.formatter.PrintSuccess(fmt.Sprintf("Backed up snapshot %s with %s as %s: %d file(s), %d changed",
+			id, job, snap.ID, snap.Files, snap.Changed))
+	})
+}
+
+// delete removes a snapshot and releases the content only it preserved
+func (c *SnapshotCommand) delete(ctx context.Context, id string) error {
+	if err := c.client.DeleteSnapshot(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete snapshot: %w", err)
+	}
+	c.formatter.PrintSuccess(fmt.Sprintf("Deleted snapshot %s", id))
+	return nil
+}
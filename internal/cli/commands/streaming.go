@@ -22,8 +22,11 @@ type StreamingCommand struct {
 func NewStreamingCommand(client *client.Client, formatter *formatter.Formatter, streamingManager *files.StreamingManager) *StreamingCommand {
 	return &StreamingCommand{
 		BaseCommand: BaseCommand{
-			client:    client,
-			formatter: formatter,
+			name:        "stream",
+			description: "File streaming operations",
+			usage:       "stream [upload|download|get|progress|pause|resume|cancel|list|settings|update-settings] [args...]",
+			client:      client,
+			formatter:   formatter,
 		},
 		streamingManager: streamingManager,
 	}
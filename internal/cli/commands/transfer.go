@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Skpow1234/Peervault/internal/cli/config"
+	"github.com/Skpow1234/Peervault/internal/cli/formatter"
+	"github.com/Skpow1234/Peervault/internal/cli/terminal"
+	"github.com/Skpow1234/Peervault/internal/cli/transfer"
+)
+
+// parseParallelFlag removes --parallel N from args and returns the number
+// of chunks to transfer at once
+func parseParallelFlag(args []string) ([]string, int, error) {
+	var rest []string
+	workers := transfer.DefaultWorkers
+	for i := 0; i < len(args); i++ {
+		if args[i] != "--parallel" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if i+1 >= len(args) {
+			return nil, 0, fmt.Errorf("usage: --parallel requires a number")
+		}
+		i++
+		n, err := strconv.Atoi(args[i])
+		if err != nil || n < 1 || n > transfer.MaxWorkers {
+			return nil, 0, fmt.Errorf("usage: --parallel must be between 1 and %d", transfer.MaxWorkers)
+		}
+		workers = n
+	}
+	return rest, workers, nil
+}
+
+// newTransferOptions sets up a transfer with a progress bar on stderr,
+// unless messages are suppressed or stderr is not a terminal. The
+// returned function ends the bar.
+func newTransferOptions(f *formatter.Formatter, label string, workers int) (transfer.Options, func()) {
+	opts := transfer.Options{
+		Workers:  workers,
+		Retries:  3,
+		StateDir: filepath.Join(config.GetConfigDir(), "transfers"),
+	}
+	if f.Quiet() || !terminal.IsTerminalFile(os.Stderr) {
+		return opts, func() {}
+	}
+
+	var bar *formatter.TransferBar
+	opts.OnProgress = func(p transfer.Progress) {
+		if bar == nil {
+			bar = formatter.NewTransferBar(label, p.Total)
+		}
+		bar.Update(p.Done, p.Resumed)
+	}
+	return opts, func() {
+		if bar != nil {
+			bar.Complete()
+		}
+	}
+}
@@ -0,0 +1,418 @@
+package completion
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Shells lists the shells completion scripts can be generated for
+var Shells = []string{"bash", "zsh", "fish", "powershell"}
+
+// Command describes one registered command for a completion script
+type Command struct {
+	Name        string
+	Description string
+	Subcommands []string
+	Flags       []string
+}
+
+// Flag is a global flag of the program
+type Flag struct {
+	// Name includes the dashes, e.g. "--output" or "-o"
+	Name        string
+	Description string
+	// TakesValue is set when the next word is the flag's value
+	TakesValue bool
+}
+
+var (
+	flagPattern       = regexp.MustCompile(`--[a-z][a-z0-9-]*`)
+	subcommandPattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+)
+
+// FromUsage builds a Command from a usage string such as
+// "backup [jobs|run <job> [--full]|list <job>]" or
+// "attr set <file_id> key=value | attr find key=value". Subcommands are the
+// literal words allowed right after the command; flags are every --flag
+// mentioned.
+func FromUsage(name, description, usage string) Command {
+	cmd := Command{Name: name, Description: description}
+	seen := make(map[string]bool)
+	for _, alt := range splitTopLevel(usage, " | ") {
+		// The first word of each alternative is the command itself
+		_, rest, _ := strings.Cut(strings.TrimSpace(alt), " ")
+		for _, word := range leadingWords(strings.TrimSpace(rest)) {
+			if !seen[word] {
+				seen[word] = true
+				cmd.Subcommands = append(cmd.Subcommands, word)
+			}
+		}
+	}
+	for _, flag := range flagPattern.FindAllString(usage, -1) {
+		if !seen[flag] {
+			seen[flag] = true
+			cmd.Flags = append(cmd.Flags, flag)
+		}
+	}
+	return cmd
+}
+
+// leadingWords returns the literal words the first argument of usage can be
+func leadingWords(usage string) []string {
+	if usage == "" || strings.HasPrefix(usage, "<") {
+		return nil
+	}
+	first := usage
+	if strings.HasPrefix(usage, "[") {
+		end := closingBracket(usage)
+		if end < 0 {
+			return nil
+		}
+		first = usage[1:end]
+	} else if i := strings.IndexByte(usage, ' '); i >= 0 {
+		first = usage[:i]
+	}
+
+	var words []string
+	for _, option := range splitTopLevel(first, "|") {
+		word, _, _ := strings.Cut(strings.TrimSpace(option), " ")
+		if subcommandPattern.MatchString(word) {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// closingBracket returns the index of the bracket closing s[0]
+func closingBracket(s string) int {
+	depth := 0
+	for i, r := range s {
+		switch r {
+		case '[', '<':
+			depth++
+		case ']', '>':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitTopLevel splits s on sep outside [] and <> groups
+func splitTopLevel(s, sep string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[', '<':
+			depth++
+		case ']', '>':
+			depth--
+		default:
+			if depth == 0 && strings.HasPrefix(s[i:], sep) {
+				parts = append(parts, s[start:i])
+				start = i + len(sep)
+				i += len(sep) - 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// Generate writes the completion script for shell. Commands are completed
+// first, then a command's subcommands in the next position and its flags
+// after a dash; anything else falls back to file names.
+func Generate(w io.Writer, shell, program string, flags []Flag, commands []Command) error {
+	commands = append([]Command(nil), commands...)
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+
+	s := &script{program: program, fn: "_" + strings.NewReplacer("-", "_", ".", "_").Replace(program), flags: flags, commands: commands}
+	switch shell {
+	case "bash":
+		s.bash()
+	case "zsh":
+		s.zsh()
+	case "fish":
+		s.fish()
+	case "powershell":
+		s.powershell()
+	default:
+		return fmt.Errorf("unsupported shell: %s (supported: %s)", shell, strings.Join(Shells, ", "))
+	}
+	_, err := io.WriteString(w, s.String())
+	return err
+}
+
+type script struct {
+	strings.Builder
+	program  string
+	fn       string
+	flags    []Flag
+	commands []Command
+}
+
+func (s *script) line(format string, args ...interface{}) {
+	fmt.Fprintf(s, format, args...)
+	s.WriteByte('\n')
+}
+
+func (s *script) flagNames() []string {
+	names := make([]string, len(s.flags))
+	for i, f := range s.flags {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// valueFlags returns the global flags followed by a value
+func (s *script) valueFlags() []string {
+	var names []string
+	for _, f := range s.flags {
+		if f.TakesValue {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+func (s *script) commandNames() []string {
+	names := make([]string, len(s.commands))
+	for i, c := range s.commands {
+		names[i] = c.Name
+	}
+	return names
+}
+
+func (s *script) bash() {
+	s.line("# bash completion for %s", s.program)
+	s.line("# Load with: source <(%s completion bash)", s.program)
+	s.line("%s() {", s.fn)
+	s.line(`    local cur="${COMP_WORDS[COMP_CWORD]}" cmd="" i`)
+	s.line("    for ((i = 1; i < COMP_CWORD; i++)); do")
+	s.line(`        case "${COMP_WORDS[i]}" in`)
+	if names := s.valueFlags(); len(names) > 0 {
+		s.line("            %s) ((i++)) ;;", strings.Join(names, "|"))
+	}
+	s.line("            -*) ;;")
+	s.line(`            *) cmd="${COMP_WORDS[i]}"; break ;;`)
+	s.line("        esac")
+	s.line("    done")
+	s.line("")
+	s.line(`    if [[ -z "$cmd" ]]; then`)
+	s.line(`        if [[ "$cur" == -* ]]; then`)
+	s.line(`            COMPREPLY=($(compgen -W "%s" -- "$cur"))`, strings.Join(s.flagNames(), " "))
+	s.line("        else")
+	s.line(`            COMPREPLY=($(compgen -W "%s" -- "$cur"))`, strings.Join(s.commandNames(), " "))
+	s.line("        fi")
+	s.line("        return")
+	s.line("    fi")
+	s.line("")
+	s.line(`    local subcommands="" flags=""`)
+	s.line(`    case "$cmd" in`)
+	for _, c := range s.commands {
+		if len(c.Subcommands) == 0 && len(c.Flags) == 0 {
+			continue
+		}
+		s.line(`        %s) subcommands="%s"; flags="%s" ;;`, c.Name, strings.Join(c.Subcommands, " "), strings.Join(c.Flags, " "))
+	}
+	s.line("    esac")
+	s.line(`    if [[ "$cur" == -* ]]; then`)
+	s.line(`        COMPREPLY=($(compgen -W "$flags" -- "$cur"))`)
+	s.line(`    elif ((i + 1 == COMP_CWORD)) && [[ -n "$subcommands" ]]; then`)
+	s.line(`        COMPREPLY=($(compgen -W "$subcommands" -- "$cur"))`)
+	s.line("    fi")
+	s.line("}")
+	s.line("complete -o default -F %s %s", s.fn, s.program)
+}
+
+func (s *script) zsh() {
+	s.line("#compdef %s", s.program)
+	s.line("# zsh completion for %s", s.program)
+	s.line("# Load with: source <(%s completion zsh), or save as %s in a directory on $fpath", s.program, s.fn)
+	s.line("")
+	s.line("%s() {", s.fn)
+	s.line("    local -a commands global_flags subcommands flags")
+	s.line("    commands=(")
+	for _, c := range s.commands {
+		s.line("        %s", zshQuote(strings.ReplaceAll(c.Name, ":", `\:`)+":"+c.Description))
+	}
+	s.line("    )")
+	s.line("    global_flags=(%s)", strings.Join(s.flagNames(), " "))
+	s.line("")
+	s.line(`    local i cmd=""`)
+	s.line("    for ((i = 2; i < CURRENT; i++)); do")
+	s.line(`        case "${words[i]}" in`)
+	if names := s.valueFlags(); len(names) > 0 {
+		s.line("            %s) ((i++)) ;;", strings.Join(names, "|"))
+	}
+	s.line("            -*) ;;")
+	s.line(`            *) cmd="${words[i]}"; break ;;`)
+	s.line("        esac")
+	s.line("    done")
+	s.line("")
+	s.line(`    if [[ -z "$cmd" ]]; then`)
+	s.line(`        if [[ "$PREFIX" == -* ]]; then`)
+	s.line("            compadd -a global_flags")
+	s.line("        else")
+	s.line("            _describe -t commands '%s command' commands", s.program)
+	s.line("        fi")
+	s.line("        return")
+	s.line("    fi")
+	s.line("")
+	s.line(`    case "$cmd" in`)
+	for _, c := range s.commands {
+		if len(c.Subcommands) == 0 && len(c.Flags) == 0 {
+			continue
+		}
+		s.line("        %s) subcommands=(%s); flags=(%s) ;;", c.Name, strings.Join(c.Subcommands, " "), strings.Join(c.Flags, " "))
+	}
+	s.line("    esac")
+	s.line(`    if [[ "$PREFIX" == -* ]]; then`)
+	s.line("        compadd -a flags")
+	s.line("    elif ((i + 1 == CURRENT && ${#subcommands} > 0)); then")
+	s.line("        compadd -a subcommands")
+	s.line("    else")
+	s.line("        _files")
+	s.line("    fi")
+	s.line("}")
+	s.line("")
+	s.line(`if [[ "${funcstack[1]}" == "%s" ]]; then`, s.fn)
+	s.line(`    %s "$@"`, s.fn)
+	s.line("else")
+	s.line("    compdef %s %s", s.fn, s.program)
+	s.line("fi")
+}
+
+func (s *script) fish() {
+	s.line("# fish completion for %s", s.program)
+	s.line("# Load with: %s completion fish | source", s.program)
+	s.line("")
+	s.line("# Prints the number of arguments after the command, then the command")
+	s.line("function %s_state", s.fn)
+	s.line("    set -l tokens (commandline -opc)")
+	s.line("    set -l cmd")
+	s.line("    set -l args 0")
+	s.line("    set -l i 2")
+	s.line("    while test $i -le (count $tokens)")
+	s.line("        set -l token $tokens[$i]")
+	s.line("        if test -n \"$cmd\"")
+	s.line("            string match -q -- '-*' $token; or set args (math $args + 1)")
+	if names := s.valueFlags(); len(names) > 0 {
+		s.line("        else if contains -- $token %s", strings.Join(names, " "))
+		s.line("            set i (math $i + 1)")
+	}
+	s.line("        else if not string match -q -- '-*' $token")
+	s.line("            set cmd $token")
+	s.line("        end")
+	s.line("        set i (math $i + 1)")
+	s.line("    end")
+	s.line("    printf '%%s\\n' $args $cmd")
+	s.line("end")
+	s.line("")
+	s.line("function %s_needs_command", s.fn)
+	s.line("    set -l state (%s_state)", s.fn)
+	s.line("    test -z \"$state[2]\"")
+	s.line("end")
+	s.line("")
+	s.line("function %s_needs_subcommand", s.fn)
+	s.line("    set -l state (%s_state)", s.fn)
+	s.line("    test \"$state[2]\" = \"$argv[1]\" -a \"$state[1]\" = 0")
+	s.line("end")
+	s.line("")
+	s.line("function %s_using", s.fn)
+	s.line("    set -l state (%s_state)", s.fn)
+	s.line("    test \"$state[2]\" = \"$argv[1]\"")
+	s.line("end")
+	s.line("")
+	for _, f := range s.flags {
+		opt := "-l " + strings.TrimLeft(f.Name, "-")
+		if !strings.HasPrefix(f.Name, "--") {
+			opt = "-s " + strings.TrimLeft(f.Name, "-")
+		}
+		if f.TakesValue {
+			opt += " -r"
+		}
+		s.line("complete -c %s -n %s_needs_command %s -d %s", s.program, s.fn, opt, fishQuote(f.Description))
+	}
+	for _, c := range s.commands {
+		s.line("complete -c %s -n %s_needs_command -f -a %s -d %s", s.program, s.fn, c.Name, fishQuote(c.Description))
+	}
+	for _, c := range s.commands {
+		if len(c.Subcommands) > 0 {
+			s.line("complete -c %s -n '%s_needs_subcommand %s' -f -a %s", s.program, s.fn, c.Name, fishQuote(strings.Join(c.Subcommands, " ")))
+		}
+		for _, f := range c.Flags {
+			s.line("complete -c %s -n '%s_using %s' -l %s", s.program, s.fn, c.Name, strings.TrimPrefix(f, "--"))
+		}
+	}
+}
+
+func (s *script) powershell() {
+	s.line("# powershell completion for %s", s.program)
+	s.line("# Load with: %s completion powershell | Out-String | Invoke-Expression", s.program)
+	s.line("Register-ArgumentCompleter -Native -CommandName '%s' -ScriptBlock {", s.program)
+	s.line("    param($wordToComplete, $commandAst, $cursorPosition)")
+	s.line("")
+	s.line("    $commands = [ordered]@{")
+	for _, c := range s.commands {
+		s.line("        %s = @{ Subcommands = @(%s); Flags = @(%s) }", psQuote(c.Name), psList(c.Subcommands), psList(c.Flags))
+	}
+	s.line("    }")
+	s.line("    $globalFlags = @(%s)", psList(s.flagNames()))
+	s.line("    $valueFlags = @(%s)", psList(s.valueFlags()))
+	s.line("")
+	s.line("    $words = @($commandAst.CommandElements | Where-Object { $_.Extent.EndOffset -lt $cursorPosition } | ForEach-Object { $_.ToString() })")
+	s.line("    $command = $null")
+	s.line("    $position = 0")
+	s.line("    for ($i = 1; $i -lt $words.Count; $i++) {")
+	s.line("        $word = $words[$i]")
+	s.line("        if ($command) {")
+	s.line("            if (-not $word.StartsWith('-')) { $position++ }")
+	s.line("        } elseif ($valueFlags -contains $word) {")
+	s.line("            $i++")
+	s.line("        } elseif (-not $word.StartsWith('-')) {")
+	s.line("            $command = $word")
+	s.line("        }")
+	s.line("    }")
+	s.line("")
+	s.line("    if (-not $command) {")
+	s.line("        $candidates = if ($wordToComplete.StartsWith('-')) { $globalFlags } else { $commands.Keys }")
+	s.line("    } elseif (-not $commands.Contains($command)) {")
+	s.line("        return")
+	s.line("    } elseif ($wordToComplete.StartsWith('-')) {")
+	s.line("        $candidates = $commands[$command].Flags")
+	s.line("    } elseif ($position -eq 0) {")
+	s.line("        $candidates = $commands[$command].Subcommands")
+	s.line("    } else {")
+	s.line("        return")
+	s.line("    }")
+	s.line("    $candidates | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {")
+	s.line("        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)")
+	s.line("    }")
+	s.line("}")
+}
+
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func psList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = psQuote(item)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,55 @@
+package completion
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromUsage(t *testing.T) {
+	tests := []struct {
+		usage       string
+		subcommands []string
+		flags       []string
+	}{
+		{"store <file_path> [--tag a,b] [--meta key=value]", nil, []string{"--tag", "--meta"}},
+		{"backup [jobs|run <job> [--full]|list <job>|show <job> <snapshot>]", []string{"jobs", "run", "list", "show"}, []string{"--full"}},
+		{"lock [list|status <file_id>|retain <file_id> <30d|RFC3339>|hold <file_id>]", []string{"list", "status", "retain", "hold"}, nil},
+		{"lifecycle [policy|policy set <rules.json>|dry-run|run --confirm|report]", []string{"policy", "dry-run", "run", "report"}, []string{"--confirm"}},
+		{"tag [add|remove] <file_id> <tag> [tag...] | tag find <tag> [tag...]", []string{"add", "remove", "find"}, nil},
+		{"search <query> [--limit N] | search status | search rebuild", []string{"status", "rebuild"}, []string{"--limit"}},
+		{"import <dir|file.tar[.gz]|s3://bucket/prefix> [--workers N]", nil, []string{"--workers"}},
+		{"completion bash|zsh|fish|powershell", []string{"bash", "zsh", "fish", "powershell"}, nil},
+		{"replication [status]", []string{"status"}, nil},
+		{"health", nil, nil},
+		{"", nil, nil},
+	}
+	for _, tt := range tests {
+		cmd := FromUsage("x", "", tt.usage)
+		assert.Equal(t, tt.subcommands, cmd.Subcommands, tt.usage)
+		assert.Equal(t, tt.flags, cmd.Flags, tt.usage)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	flags := []Flag{{Name: "--output", TakesValue: true}, {Name: "-q"}}
+	commands := []Command{
+		FromUsage("store", "Store a file", "store <file_path> [--tag a,b]"),
+		FromUsage("backup", "Run backups", "backup [jobs|run <job> [--full]]"),
+	}
+
+	for _, shell := range Shells {
+		var buf bytes.Buffer
+		require.NoError(t, Generate(&buf, shell, "peervault-cli", flags, commands), shell)
+		script := buf.String()
+		// fish names long flags without the dashes
+		for _, word := range []string{"peervault-cli", "backup", "store", "jobs", "full", "tag", "output"} {
+			assert.Contains(t, script, word, shell)
+		}
+	}
+
+	err := Generate(&bytes.Buffer{}, "tcsh", "peervault-cli", flags, commands)
+	assert.Error(t, err)
+}
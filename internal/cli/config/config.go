@@ -5,18 +5,38 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // Config represents CLI configuration
 type Config struct {
-	ServerURL    string            `json:"server_url"`
-	AuthToken    string            `json:"auth_token"`
-	HistoryFile  string            `json:"history_file"`
-	OutputFormat string            `json:"output_format"`
-	Theme        string            `json:"theme"`
-	AutoComplete bool              `json:"auto_complete"`
-	Verbose      bool              `json:"verbose"`
-	Aliases      map[string]string `json:"aliases"`
+	ServerURL    string              `json:"server_url"`
+	AuthToken    string              `json:"auth_token"`
+	HistoryFile  string              `json:"history_file"`
+	OutputFormat string              `json:"output_format"`
+	Theme        string              `json:"theme"`
+	AutoComplete bool                `json:"auto_complete"`
+	Verbose      bool                `json:"verbose"`
+	Aliases      map[string]string   `json:"aliases"`
+	Profile      string              `json:"profile,omitempty"`
+	Profiles     map[string]*Profile `json:"profiles,omitempty"`
+}
+
+// Profile is a named server connection. Its token lives in the OS keyring
+// rather than here, unless it is read from TokenFile on each use.
+type Profile struct {
+	ServerURL string     `json:"server_url"`
+	TokenFile string     `json:"token_file,omitempty"`
+	TLS       *TLSConfig `json:"tls,omitempty"`
+}
+
+// TLSConfig configures HTTPS connections to a profile's server
+type TLSConfig struct {
+	CACert             string `json:"ca_cert,omitempty"`
+	ClientCert         string `json:"client_cert,omitempty"`
+	ClientKey          string `json:"client_key,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
 }
 
 // Default returns default configuration
@@ -106,8 +126,11 @@ func (c *Config) Set(key, value string) error {
 	case "auth_token":
 		c.AuthToken = value
 	case "output_format":
-		if value != "table" && value != "json" && value != "yaml" {
-			return fmt.Errorf("invalid output format: %s (must be table, json, or yaml)", value)
+		switch {
+		case value == "table", value == "json", value == "yaml", value == "csv":
+		case strings.HasPrefix(value, "go-template="):
+		default:
+			return fmt.Errorf("invalid output format: %s (must be table, json, yaml, csv or go-template=<template>)", value)
 		}
 		c.OutputFormat = value
 	case "theme":
@@ -2,8 +2,11 @@ package formatter
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/Skpow1234/Peervault/internal/cli/client"
@@ -12,7 +15,15 @@ import (
 // Formatter handles output formatting
 type Formatter struct {
 	outputFormat string
-	verbose      bool
+	// template renders results for the go-template format
+	template *template.Template
+	verbose  bool
+	// quiet suppresses info and success messages
+	quiet bool
+	// color enables emoji and ANSI decoration of messages
+	color bool
+	// messages receives status messages; results always go to stdout
+	messages io.Writer
 }
 
 // New creates a new formatter
@@ -20,109 +31,124 @@ func New() *Formatter {
 	return &Formatter{
 		outputFormat: "table",
 		verbose:      false,
+		color:        true,
+		messages:     os.Stdout,
 	}
 }
 
-// SetOutputFormat sets the output format
-func (f *Formatter) SetOutputFormat(format string) {
-	f.outputFormat = format
-}
-
 // SetVerbose sets verbose mode
 func (f *Formatter) SetVerbose(verbose bool) {
 	f.verbose = verbose
 }
 
+// SetQuiet suppresses info and success messages; warnings, errors and
+// results are still printed
+func (f *Formatter) SetQuiet(quiet bool) {
+	f.quiet = quiet
+}
+
+// Quiet reports whether quiet mode is on
+func (f *Formatter) Quiet() bool {
+	return f.quiet
+}
+
+// SetColor turns emoji and ANSI decoration on or off
+func (f *Formatter) SetColor(color bool) {
+	f.color = color
+}
+
+// SetMessageOutput sends status messages to w, e.g. stderr so scripts can
+// pipe results without them
+func (f *Formatter) SetMessageOutput(w io.Writer) {
+	f.messages = w
+}
+
 // PrintError prints an error message
 func (f *Formatter) PrintError(err error) {
+	if !f.color {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
 	fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
 }
 
 // PrintSuccess prints a success message
 func (f *Formatter) PrintSuccess(message string) {
-	fmt.Printf("✅ %s\n", message)
+	if !f.quiet {
+		f.printMessage("✅ ", "", message)
+	}
 }
 
 // PrintInfo prints an info message
 func (f *Formatter) PrintInfo(message string) {
-	fmt.Printf("ℹ️  %s\n", message)
+	if !f.quiet {
+		f.printMessage("ℹ️  ", "", message)
+	}
 }
 
 // PrintWarning prints a warning message
 func (f *Formatter) PrintWarning(message string) {
-	fmt.Printf("⚠️  %s\n", message)
+	f.printMessage("⚠️  ", "Warning: ", message)
 }
 
-// PrintFileInfo prints file information
-func (f *Formatter) PrintFileInfo(file *client.FileInfo) {
-	switch f.outputFormat {
-	case "json":
-		f.printFileInfoJSON(file)
-	case "yaml":
-		f.printFileInfoYAML(file)
-	default:
-		f.printFileInfoTable(file)
+func (f *Formatter) printMessage(icon, plain, message string) {
+	if f.color {
+		fmt.Fprintf(f.messages, "%s%s\n", icon, message)
+	} else {
+		fmt.Fprintf(f.messages, "%s%s\n", plain, message)
 	}
 }
 
+// PrintFileInfo prints file information
+func (f *Formatter) PrintFileInfo(file *client.FileInfo) error {
+	return f.PrintResult(file, func() { f.printFileInfoTable(file) })
+}
+
 // PrintFileList prints a list of files
-func (f *Formatter) PrintFileList(files *client.FileListResponse) {
-	switch f.outputFormat {
-	case "json":
-		f.printFileListJSON(files)
-	case "yaml":
-		f.printFileListYAML(files)
-	default:
-		f.printFileListTable(files)
-	}
+func (f *Formatter) PrintFileList(files *client.FileListResponse) error {
+	return f.PrintResult(files, func() { f.printFileListTable(files) })
+}
+
+// PrintSearchResults prints ranked search results with highlighted snippets
+func (f *Formatter) PrintSearchResults(results *client.SearchResults) error {
+	return f.PrintResult(results, func() {
+		if len(results.Results) == 0 {
+			f.PrintInfo(fmt.Sprintf("No documents match %q", results.Query))
+			return
+		}
+		fmt.Printf("%d result(s) for %q in %.1fms\n\n", results.Total, results.Query, results.TookMs)
+		for i, hit := range results.Results {
+			fmt.Printf("%d. %s  (%s, score %.3f)\n", results.Offset+i+1, hit.Name, hit.Key, hit.Score)
+			highlight := strings.NewReplacer("<mark>", "\033[1;33m", "</mark>", "\033[0m")
+			if !f.color {
+				highlight = strings.NewReplacer("<mark>", "", "</mark>", "")
+			}
+			for _, h := range hit.Highlights {
+				h = highlight.Replace(h)
+				fmt.Printf("   %s\n", h)
+			}
+		}
+	})
 }
 
 // PrintPeerInfo prints peer information
-func (f *Formatter) PrintPeerInfo(peer *client.PeerInfo) {
-	switch f.outputFormat {
-	case "json":
-		f.printPeerInfoJSON(peer)
-	case "yaml":
-		f.printPeerInfoYAML(peer)
-	default:
-		f.printPeerInfoTable(peer)
-	}
+func (f *Formatter) PrintPeerInfo(peer *client.PeerInfo) error {
+	return f.PrintResult(peer, func() { f.printPeerInfoTable(peer) })
 }
 
 // PrintPeerList prints a list of peers
-func (f *Formatter) PrintPeerList(peers *client.PeerListResponse) {
-	switch f.outputFormat {
-	case "json":
-		f.printPeerListJSON(peers)
-	case "yaml":
-		f.printPeerListYAML(peers)
-	default:
-		f.printPeerListTable(peers)
-	}
+func (f *Formatter) PrintPeerList(peers *client.PeerListResponse) error {
+	return f.PrintResult(peers, func() { f.printPeerListTable(peers) })
 }
 
 // PrintHealth prints health status
-func (f *Formatter) PrintHealth(health *client.HealthStatus) {
-	switch f.outputFormat {
-	case "json":
-		f.printHealthJSON(health)
-	case "yaml":
-		f.printHealthYAML(health)
-	default:
-		f.printHealthTable(health)
-	}
+func (f *Formatter) PrintHealth(health *client.HealthStatus) error {
+	return f.PrintResult(health, func() { f.printHealthTable(health) })
 }
 
 // PrintMetrics prints system metrics
-func (f *Formatter) PrintMetrics(metrics *client.Metrics) {
-	switch f.outputFormat {
-	case "json":
-		f.printMetricsJSON(metrics)
-	case "yaml":
-		f.printMetricsYAML(metrics)
-	default:
-		f.printMetricsTable(metrics)
-	}
+func (f *Formatter) PrintMetrics(metrics *client.Metrics) error {
+	return f.PrintResult(metrics, func() { f.printMetricsTable(metrics) })
 }
 
 // Table formatting methods
@@ -137,6 +163,12 @@ func (f *Formatter) printFileInfoTable(file *client.FileInfo) {
 	fmt.Printf("│ Hash            │ %-61s │\n", file.Hash)
 	fmt.Printf("│ Created At      │ %-61s │\n", file.CreatedAt.Format(time.RFC3339))
 	fmt.Printf("│ Owner           │ %-61s │\n", file.Owner)
+	if len(file.Tags) > 0 {
+		fmt.Printf("│ Tags            │ %-61s │\n", strings.Join(file.Tags, ", "))
+	}
+	for _, k := range sortedKeys(file.Metadata) {
+		fmt.Printf("│ %-15s │ %-61s │\n", truncate("meta."+k, 15), truncate(file.Metadata[k], 61))
+	}
 	fmt.Printf("└─────────────────┴─────────────────────────────────────────────────────────────┘\n")
 }
 
@@ -235,162 +267,6 @@ func (f *Formatter) printMetricsTable(metrics *client.Metrics) {
 	fmt.Printf("└─────────────────────────────────────────────────────────────┴─────────────────────────────────────────────────────────────┘\n")
 }
 
-// JSON formatting methods
-func (f *Formatter) printFileInfoJSON(file *client.FileInfo) {
-	// Simple JSON output - in a real implementation, you'd use proper JSON marshaling
-	fmt.Printf("{\n")
-	fmt.Printf("  \"id\": \"%s\",\n", file.ID)
-	fmt.Printf("  \"key\": \"%s\",\n", file.Key)
-	fmt.Printf("  \"size\": %d,\n", file.Size)
-	fmt.Printf("  \"hash\": \"%s\",\n", file.Hash)
-	fmt.Printf("  \"created_at\": \"%s\",\n", file.CreatedAt.Format(time.RFC3339))
-	fmt.Printf("  \"owner\": \"%s\"\n", file.Owner)
-	fmt.Printf("}\n")
-}
-
-func (f *Formatter) printFileListJSON(files *client.FileListResponse) {
-	fmt.Printf("{\n")
-	fmt.Printf("  \"files\": [\n")
-	for i, file := range files.Files {
-		fmt.Printf("    {\n")
-		fmt.Printf("      \"id\": \"%s\",\n", file.ID)
-		fmt.Printf("      \"key\": \"%s\",\n", file.Key)
-		fmt.Printf("      \"size\": %d,\n", file.Size)
-		fmt.Printf("      \"hash\": \"%s\",\n", file.Hash)
-		fmt.Printf("      \"created_at\": \"%s\",\n", file.CreatedAt.Format(time.RFC3339))
-		fmt.Printf("      \"owner\": \"%s\"\n", file.Owner)
-		if i < len(files.Files)-1 {
-			fmt.Printf("    },\n")
-		} else {
-			fmt.Printf("    }\n")
-		}
-	}
-	fmt.Printf("  ],\n")
-	fmt.Printf("  \"total\": %d\n", files.Total)
-	fmt.Printf("}\n")
-}
-
-func (f *Formatter) printPeerInfoJSON(peer *client.PeerInfo) {
-	fmt.Printf("{\n")
-	fmt.Printf("  \"id\": \"%s\",\n", peer.ID)
-	fmt.Printf("  \"address\": \"%s\",\n", peer.Address)
-	fmt.Printf("  \"status\": \"%s\",\n", peer.Status)
-	fmt.Printf("  \"latency\": %d,\n", peer.Latency)
-	fmt.Printf("  \"storage\": %d,\n", peer.Storage)
-	fmt.Printf("  \"last_seen\": \"%s\"\n", peer.LastSeen.Format(time.RFC3339))
-	fmt.Printf("}\n")
-}
-
-func (f *Formatter) printPeerListJSON(peers *client.PeerListResponse) {
-	fmt.Printf("{\n")
-	fmt.Printf("  \"peers\": [\n")
-	for i, peer := range peers.Peers {
-		fmt.Printf("    {\n")
-		fmt.Printf("      \"id\": \"%s\",\n", peer.ID)
-		fmt.Printf("      \"address\": \"%s\",\n", peer.Address)
-		fmt.Printf("      \"status\": \"%s\",\n", peer.Status)
-		fmt.Printf("      \"latency\": %d,\n", peer.Latency)
-		fmt.Printf("      \"storage\": %d,\n", peer.Storage)
-		fmt.Printf("      \"last_seen\": \"%s\"\n", peer.LastSeen.Format(time.RFC3339))
-		if i < len(peers.Peers)-1 {
-			fmt.Printf("    },\n")
-		} else {
-			fmt.Printf("    }\n")
-		}
-	}
-	fmt.Printf("  ],\n")
-	fmt.Printf("  \"total\": %d\n", peers.Total)
-	fmt.Printf("}\n")
-}
-
-func (f *Formatter) printHealthJSON(health *client.HealthStatus) {
-	fmt.Printf("{\n")
-	fmt.Printf("  \"status\": \"%s\",\n", health.Status)
-	fmt.Printf("  \"timestamp\": \"%s\",\n", health.Timestamp.Format(time.RFC3339))
-	fmt.Printf("  \"services\": {\n")
-	i := 0
-	for service, status := range health.Services {
-		if i < len(health.Services)-1 {
-			fmt.Printf("    \"%s\": \"%s\",\n", service, status)
-		} else {
-			fmt.Printf("    \"%s\": \"%s\"\n", service, status)
-		}
-		i++
-	}
-	fmt.Printf("  }\n")
-	fmt.Printf("}\n")
-}
-
-func (f *Formatter) printMetricsJSON(metrics *client.Metrics) {
-	fmt.Printf("{\n")
-	fmt.Printf("  \"files_stored\": %d,\n", metrics.FilesStored)
-	fmt.Printf("  \"network_traffic\": %.2f,\n", metrics.NetworkTraffic)
-	fmt.Printf("  \"active_peers\": %d,\n", metrics.ActivePeers)
-	fmt.Printf("  \"storage_used\": %d\n", metrics.StorageUsed)
-	fmt.Printf("}\n")
-}
-
-// YAML formatting methods (simplified)
-func (f *Formatter) printFileInfoYAML(file *client.FileInfo) {
-	fmt.Printf("id: %s\n", file.ID)
-	fmt.Printf("key: %s\n", file.Key)
-	fmt.Printf("size: %d\n", file.Size)
-	fmt.Printf("hash: %s\n", file.Hash)
-	fmt.Printf("created_at: %s\n", file.CreatedAt.Format(time.RFC3339))
-	fmt.Printf("owner: %s\n", file.Owner)
-}
-
-func (f *Formatter) printFileListYAML(files *client.FileListResponse) {
-	fmt.Printf("files:\n")
-	for _, file := range files.Files {
-		fmt.Printf("  - id: %s\n", file.ID)
-		fmt.Printf("    key: %s\n", file.Key)
-		fmt.Printf("    size: %d\n", file.Size)
-		fmt.Printf("    hash: %s\n", file.Hash)
-		fmt.Printf("    created_at: %s\n", file.CreatedAt.Format(time.RFC3339))
-		fmt.Printf("    owner: %s\n", file.Owner)
-	}
-	fmt.Printf("total: %d\n", files.Total)
-}
-
-func (f *Formatter) printPeerInfoYAML(peer *client.PeerInfo) {
-	fmt.Printf("id: %s\n", peer.ID)
-	fmt.Printf("address: %s\n", peer.Address)
-	fmt.Printf("status: %s\n", peer.Status)
-	fmt.Printf("latency: %d\n", peer.Latency)
-	fmt.Printf("storage: %d\n", peer.Storage)
-	fmt.Printf("last_seen: %s\n", peer.LastSeen.Format(time.RFC3339))
-}
-
-func (f *Formatter) printPeerListYAML(peers *client.PeerListResponse) {
-	fmt.Printf("peers:\n")
-	for _, peer := range peers.Peers {
-		fmt.Printf("  - id: %s\n", peer.ID)
-		fmt.Printf("    address: %s\n", peer.Address)
-		fmt.Printf("    status: %s\n", peer.Status)
-		fmt.Printf("    latency: %d\n", peer.Latency)
-		fmt.Printf("    storage: %d\n", peer.Storage)
-		fmt.Printf("    last_seen: %s\n", peer.LastSeen.Format(time.RFC3339))
-	}
-	fmt.Printf("total: %d\n", peers.Total)
-}
-
-func (f *Formatter) printHealthYAML(health *client.HealthStatus) {
-	fmt.Printf("status: %s\n", health.Status)
-	fmt.Printf("timestamp: %s\n", health.Timestamp.Format(time.RFC3339))
-	fmt.Printf("services:\n")
-	for service, status := range health.Services {
-		fmt.Printf("  %s: %s\n", service, status)
-	}
-}
-
-func (f *Formatter) printMetricsYAML(metrics *client.Metrics) {
-	fmt.Printf("files_stored: %d\n", metrics.FilesStored)
-	fmt.Printf("network_traffic: %.2f\n", metrics.NetworkTraffic)
-	fmt.Printf("active_peers: %d\n", metrics.ActivePeers)
-	fmt.Printf("storage_used: %d\n", metrics.StorageUsed)
-}
-
 // ClearScreen clears the terminal screen
 func (f *Formatter) ClearScreen() {
 	fmt.Print("\033[H\033[2J")
@@ -423,16 +299,7 @@ func (f *Formatter) PrintSpinner(message string) *Spinner {
 
 // Utility methods
 func (f *Formatter) formatBytes(bytes int64) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
-	}
-	div, exp := int64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
-	}
-	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+	return byteSize(bytes)
 }
 
 func (f *Formatter) formatLatency(latency int64) string {
@@ -478,6 +345,12 @@ func (f *Formatter) FormatBytes(bytes int64) string {
 
 // PrintTable prints a table with headers and rows
 func (f *Formatter) PrintTable(headers []string, rows [][]string) {
+	if f.outputFormat != FormatTable {
+		if err := f.printRows(headers, rows); err != nil {
+			f.PrintError(err)
+		}
+		return
+	}
 	if len(headers) == 0 || len(rows) == 0 {
 		return
 	}
@@ -521,3 +394,21 @@ func (f *Formatter) PrintTable(headers []string, rows [][]string) {
 		fmt.Println()
 	}
 }
+
+// sortedKeys returns the keys of a string map in sorted order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
@@ -2,11 +2,13 @@ package formatter
 
 import (
 	"fmt"
+	"os"
 	"strings"
 	"time"
 )
 
-// ProgressBar represents a progress bar for long operations
+// ProgressBar represents a progress bar for long operations. It is drawn
+// on stderr so results piped from stdout stay clean.
 type ProgressBar struct {
 	total      int
 	current    int
@@ -30,18 +32,25 @@ func NewProgressBar(total int) *ProgressBar {
 func (pb *ProgressBar) Update(current int, message string) {
 	pb.current = current
 	pb.message = message
-	pb.lastUpdate = time.Now()
 
 	// Only update if enough time has passed (throttle updates)
 	if time.Since(pb.lastUpdate) < 100*time.Millisecond {
 		return
 	}
+	pb.lastUpdate = time.Now()
 
 	pb.render()
 }
 
 // render renders the progress bar
 func (pb *ProgressBar) render() {
+	// Without a known total only the count can be shown
+	if pb.total <= 0 {
+		fmt.Fprintf(os.Stderr, "\r\033[K")
+		fmt.Fprintf(os.Stderr, "Progress: %d %s", pb.current, pb.message)
+		return
+	}
+
 	percentage := float64(pb.current) / float64(pb.total)
 	filled := int(percentage * float64(pb.width))
 	empty := pb.width - filled
@@ -57,8 +66,8 @@ func (pb *ProgressBar) render() {
 		eta = time.Duration(remaining) * time.Second
 	}
 
-	fmt.Printf("\r\033[K") // Clear line
-	fmt.Printf("Progress: [%s] %.1f%% (%d/%d) %s ETA: %s",
+	fmt.Fprintf(os.Stderr, "\r\033[K") // Clear line
+	fmt.Fprintf(os.Stderr, "Progress: [%s] %.1f%% (%d/%d) %s ETA: %s",
 		bar, percentage*100, pb.current, pb.total, pb.message, formatDuration(eta))
 }
 
@@ -67,7 +76,74 @@ func (pb *ProgressBar) Complete(message string) {
 	pb.current = pb.total
 	pb.message = message
 	pb.render()
-	fmt.Println() // New line
+	fmt.Fprintln(os.Stderr) // New line
+}
+
+// TransferBar shows the progress of a byte transfer with its rate and ETA.
+// Like ProgressBar it is drawn on stderr.
+type TransferBar struct {
+	label      string
+	total      int64
+	done       int64
+	resumed    int64
+	width      int
+	startTime  time.Time
+	lastUpdate time.Time
+}
+
+// NewTransferBar creates a bar for a transfer of total bytes
+func NewTransferBar(label string, total int64) *TransferBar {
+	return &TransferBar{
+		label:     label,
+		total:     total,
+		width:     30,
+		startTime: time.Now(),
+	}
+}
+
+// Update records done bytes transferred, resumed of them by an earlier run
+func (tb *TransferBar) Update(done, resumed int64) {
+	tb.done = done
+	tb.resumed = resumed
+
+	// Only update if enough time has passed (throttle updates)
+	if time.Since(tb.lastUpdate) < 100*time.Millisecond {
+		return
+	}
+	tb.lastUpdate = time.Now()
+
+	tb.render()
+}
+
+// render renders the transfer bar
+func (tb *TransferBar) render() {
+	percentage := 1.0
+	if tb.total > 0 {
+		percentage = float64(tb.done) / float64(tb.total)
+	}
+	filled := min(int(percentage*float64(tb.width)), tb.width)
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", tb.width-filled)
+
+	// The rate only counts what this run transferred
+	elapsed := time.Since(tb.startTime)
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(tb.done-tb.resumed) / elapsed.Seconds()
+	}
+	eta := "--"
+	if rate > 0 {
+		eta = formatDuration(time.Duration(float64(tb.total-tb.done)/rate) * time.Second)
+	}
+
+	fmt.Fprintf(os.Stderr, "\r\033[K") // Clear line
+	fmt.Fprintf(os.Stderr, "%s [%s] %5.1f%% %s/%s %s/s ETA: %s",
+		tb.label, bar, percentage*100, byteSize(tb.done), byteSize(tb.total), byteSize(int64(rate)), eta)
+}
+
+// Complete draws the finished bar and ends its line
+func (tb *TransferBar) Complete() {
+	tb.render()
+	fmt.Fprintln(os.Stderr) // New line
 }
 
 // Spinner represents a loading spinner
@@ -138,6 +214,20 @@ func (s *Spinner) render() {
 	fmt.Printf("%s %s", s.frames[s.index], s.message)
 }
 
+// byteSize formats a byte count for display
+func byteSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
 // formatDuration formats a duration for display
 func formatDuration(d time.Duration) string {
 	if d < time.Minute {
@@ -0,0 +1,350 @@
+package formatter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats selectable with --format
+const (
+	FormatTable    = "table"
+	FormatJSON     = "json"
+	FormatYAML     = "yaml"
+	FormatCSV      = "csv"
+	FormatTemplate = "go-template"
+)
+
+// Formats lists the accepted --format values
+var Formats = []string{FormatTable, FormatJSON, FormatYAML, FormatCSV, FormatTemplate + "=<template>"}
+
+// SetFormat selects how results are printed: table, json, yaml, csv or
+// go-template=<template>. A bare value containing "{{" is taken as a
+// template too.
+func (f *Formatter) SetFormat(spec string) error {
+	format, text, _ := strings.Cut(spec, "=")
+	if strings.Contains(spec, "{{") && format != FormatTemplate {
+		format, text = FormatTemplate, spec
+	}
+
+	switch format {
+	case FormatTable, FormatJSON, FormatYAML, FormatCSV:
+		f.template = nil
+	case FormatTemplate:
+		if text == "" {
+			return fmt.Errorf("go-template format needs a template, e.g. go-template='{{.Key}}'")
+		}
+		tmpl, err := template.New("format").Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return fmt.Errorf("invalid template: %w", err)
+		}
+		f.template = tmpl
+	default:
+		return fmt.Errorf("invalid format %q (valid: %s)", spec, strings.Join(Formats, ", "))
+	}
+	f.outputFormat = format
+	return nil
+}
+
+// Format returns the selected output format
+func (f *Formatter) Format() string {
+	return f.outputFormat
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// PrintResult prints a command result in the selected format. table prints
+// the human-readable form and is only called for the table format.
+// Templates are executed against v itself, so fields use Go names such as
+// {{.Key}}.
+func (f *Formatter) PrintResult(v interface{}, table func()) error {
+	switch f.outputFormat {
+	case FormatJSON:
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode JSON: %w", err)
+		}
+		fmt.Println(string(data))
+	case FormatYAML:
+		// Round-trip through JSON so keys match the JSON field names
+		generic, err := toGeneric(v)
+		if err != nil {
+			return err
+		}
+		data, err := yaml.Marshal(generic)
+		if err != nil {
+			return fmt.Errorf("failed to encode YAML: %w", err)
+		}
+		fmt.Print(string(data))
+	case FormatCSV:
+		header, rows := records(v)
+		return writeCSV(header, rows)
+	case FormatTemplate:
+		var out strings.Builder
+		if err := f.template.Execute(&out, v); err != nil {
+			return fmt.Errorf("failed to execute template: %w", err)
+		}
+		if s := out.String(); s != "" && !strings.HasSuffix(s, "\n") {
+			out.WriteString("\n")
+		}
+		fmt.Print(out.String())
+	default:
+		if table != nil {
+			table()
+		}
+	}
+	return nil
+}
+
+// printRows prints a PrintTable table in a structured format. Each row
+// becomes an object keyed by the snake_case headers; a two-column
+// Field/Value table becomes a single object.
+func (f *Formatter) printRows(headers []string, rows [][]string) error {
+	if f.outputFormat == FormatCSV {
+		return writeCSV(headers, rows)
+	}
+
+	keys := make([]string, len(headers))
+	for i, h := range headers {
+		keys[i] = snakeCase(h)
+	}
+	if len(keys) == 2 && keys[0] == "field" && keys[1] == "value" {
+		object := make(map[string]string, len(rows))
+		for _, row := range rows {
+			if len(row) == 2 {
+				object[snakeCase(row[0])] = row[1]
+			}
+		}
+		return f.PrintResult(object, nil)
+	}
+
+	objects := make([]map[string]string, len(rows))
+	for i, row := range rows {
+		objects[i] = make(map[string]string, len(keys))
+		for j, key := range keys {
+			if j < len(row) {
+				objects[i][key] = row[j]
+			}
+		}
+	}
+	return f.PrintResult(objects, nil)
+}
+
+func writeCSV(header []string, rows [][]string) error {
+	if len(header) == 0 {
+		return nil
+	}
+	w := csv.NewWriter(os.Stdout)
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return nil
+}
+
+func toGeneric(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode result: %w", err)
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, fmt.Errorf("failed to encode result: %w", err)
+	}
+	return generic, nil
+}
+
+// records flattens v into CSV rows. A list, or a struct holding exactly one
+// list of records, gives a row per element; anything else is a single row.
+// Columns are JSON field names; nested values are JSON-encoded.
+func records(v interface{}) ([]string, [][]string) {
+	rv := indirect(reflect.ValueOf(v))
+	if rv.Kind() == reflect.Struct {
+		if list, ok := soleList(rv); ok {
+			rv = list
+		}
+	}
+
+	var items []reflect.Value
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		for i := 0; i < rv.Len(); i++ {
+			items = append(items, indirect(rv.Index(i)))
+		}
+	} else if rv.IsValid() {
+		items = []reflect.Value{rv}
+	}
+
+	var header []string
+	seen := make(map[string]bool)
+	cells := make([]map[string]string, len(items))
+	for i, item := range items {
+		row := make(map[string]string)
+		for _, key := range flatten(item, row) {
+			if !seen[key] {
+				seen[key] = true
+				header = append(header, key)
+			}
+		}
+		cells[i] = row
+	}
+
+	rows := make([][]string, len(cells))
+	for i, row := range cells {
+		rows[i] = make([]string, len(header))
+		for j, key := range header {
+			rows[i][j] = row[key]
+		}
+	}
+	return header, rows
+}
+
+// flatten fills row with the columns of v and returns their names in order
+func flatten(v reflect.Value, row map[string]string) []string {
+	switch v.Kind() {
+	case reflect.Struct:
+		if _, ok := v.Interface().(time.Time); ok {
+			break
+		}
+		var keys []string
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if field.Anonymous && indirect(v.Field(i)).Kind() == reflect.Struct {
+				keys = append(keys, flatten(indirect(v.Field(i)), row)...)
+				continue
+			}
+			name := jsonName(field)
+			if name == "" {
+				continue
+			}
+			row[name] = cell(v.Field(i))
+			keys = append(keys, name)
+		}
+		return keys
+	case reflect.Map:
+		var keys []string
+		for _, k := range v.MapKeys() {
+			key := fmt.Sprint(k.Interface())
+			row[key] = cell(v.MapIndex(k))
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	row["value"] = cell(v)
+	return []string{"value"}
+}
+
+// cell formats one CSV value
+func cell(v reflect.Value) string {
+	v = indirect(v)
+	if !v.IsValid() {
+		return ""
+	}
+	switch value := v.Interface().(type) {
+	case time.Time:
+		if value.IsZero() {
+			return ""
+		}
+		return value.Format(time.RFC3339)
+	case time.Duration:
+		return value.String()
+	}
+	switch v.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() || v.Kind() == reflect.Map && v.IsNil() {
+			return ""
+		}
+		data, err := json.Marshal(v.Interface())
+		if err != nil {
+			return fmt.Sprint(v.Interface())
+		}
+		return string(data)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// soleList returns the only exported list-of-records field of a struct
+func soleList(v reflect.Value) (reflect.Value, bool) {
+	var list reflect.Value
+	found := 0
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Type().Field(i).IsExported() {
+			continue
+		}
+		field := v.Field(i)
+		if field.Kind() != reflect.Slice {
+			continue
+		}
+		elem := field.Type().Elem()
+		for elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		if elem.Kind() == reflect.Struct || elem.Kind() == reflect.Map {
+			list = field
+			found++
+		}
+	}
+	return list, found == 1
+}
+
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func jsonName(field reflect.StructField) string {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name
+	}
+	return field.Name
+}
+
+// snakeCase turns a table header such as "Last Sync" into "last_sync"
+func snakeCase(s string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.TrimSpace(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if underscore && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			underscore = false
+			b.WriteRune(unicode.ToLower(r))
+		} else {
+			underscore = true
+		}
+	}
+	return b.String()
+}
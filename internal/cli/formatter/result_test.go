@@ -0,0 +1,114 @@
+package formatter
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureStdout returns what fn prints to stdout
+func captureStdout(t *testing.T, fn func() error) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	fnErr := fn()
+	os.Stdout = stdout
+	require.NoError(t, w.Close())
+	require.NoError(t, fnErr)
+
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func testFiles() *client.FileListResponse {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &client.FileListResponse{
+		Files: []client.FileInfo{
+			{ID: "1", Key: "a.txt", Size: 10, CreatedAt: created, Tags: []string{"x", "y"}},
+			{ID: "2", Key: "b, c.txt", Size: 20, CreatedAt: created},
+		},
+		Total: 2,
+	}
+}
+
+func TestSetFormat(t *testing.T) {
+	f := New()
+	assert.Equal(t, FormatTable, f.Format())
+
+	for _, format := range []string{"json", "yaml", "csv", "table"} {
+		require.NoError(t, f.SetFormat(format))
+		assert.Equal(t, format, f.Format())
+	}
+
+	require.NoError(t, f.SetFormat("go-template={{.Total}}"))
+	assert.Equal(t, FormatTemplate, f.Format())
+	require.NoError(t, f.SetFormat("{{.Total}} files"))
+	assert.Equal(t, FormatTemplate, f.Format())
+
+	assert.Error(t, f.SetFormat("xml"))
+	assert.Error(t, f.SetFormat("go-template="))
+	assert.Error(t, f.SetFormat("go-template={{.Total"))
+	assert.Equal(t, FormatTemplate, f.Format(), "a rejected format keeps the previous one")
+}
+
+func TestPrintResult(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		// Every field is a column, omitempty or not, so rows line up
+		{"csv", "id,key,name,size,content_type,hash,merkle_root,created_at,owner,tags,metadata\n" +
+			"1,a.txt,,10,,,,2026-01-02T03:04:05Z,,\"[\"\"x\"\",\"\"y\"\"]\",\n" +
+			"2,\"b, c.txt\",,20,,,,2026-01-02T03:04:05Z,,,\n"},
+		{"go-template={{range .Files}}{{.Key}};{{end}}", "a.txt;b, c.txt;\n"},
+		{"go-template={{join (index .Files 0).Tags \",\"}}", "x,y\n"},
+	}
+	for _, tt := range tests {
+		f := New()
+		require.NoError(t, f.SetFormat(tt.format))
+		out := captureStdout(t, func() error { return f.PrintResult(testFiles(), nil) })
+		assert.Equal(t, tt.want, out, tt.format)
+	}
+
+	f := New()
+	require.NoError(t, f.SetFormat("yaml"))
+	out := captureStdout(t, func() error { return f.PrintResult(testFiles(), nil) })
+	assert.Contains(t, out, "total: 2\n")
+	assert.Contains(t, out, "key: a.txt\n")
+
+	f = New()
+	called := false
+	captureStdout(t, func() error { return f.PrintResult(testFiles(), func() { called = true }) })
+	assert.True(t, called, "table format prints the human-readable form")
+}
+
+func TestPrintTableStructured(t *testing.T) {
+	f := New()
+	require.NoError(t, f.SetFormat("json"))
+
+	out := captureStdout(t, func() error {
+		f.PrintTable([]string{"Field", "Value"}, [][]string{{"Last Sync", "now"}, {"Peers", "3"}})
+		return nil
+	})
+	assert.JSONEq(t, `{"last_sync": "now", "peers": "3"}`, out)
+
+	out = captureStdout(t, func() error {
+		f.PrintTable([]string{"Peer ID", "Status"}, [][]string{{"p1", "up"}})
+		return nil
+	})
+	assert.JSONEq(t, `[{"peer_id": "p1", "status": "up"}]`, out)
+}
+
+func TestSnakeCase(t *testing.T) {
+	assert.Equal(t, "last_sync", snakeCase("Last Sync"))
+	assert.Equal(t, "skipped_already_done", snakeCase("Skipped (already done)"))
+	assert.Equal(t, "id", snakeCase(" ID "))
+}
@@ -93,17 +93,21 @@ func (hm *HelpManager) initializeHelp() {
 		Examples: []string{
 			"store document.pdf",
 			"store /path/to/large-file.zip --compress",
-			"store image.jpg --encrypt --key mykey",
+			"store image.jpg --encrypt",
+			"store image.jpg --key-file ~/.peervault/photos.key",
+			"store dataset.tar --parallel 8",
 		},
 		Options: []*Option{
+			{Name: "parallel", Description: "Number of chunks uploaded at once", Required: false, Default: "4"},
 			{Name: "compress", Short: "c", Description: "Compress file before storing", Required: false, Default: "false"},
-			{Name: "encrypt", Short: "e", Description: "Encrypt file before storing", Required: false, Default: "false"},
-			{Name: "key", Short: "k", Description: "Encryption key to use", Required: false, Default: ""},
+			{Name: "encrypt", Description: "Encrypt file with a passphrase before it leaves the client", Required: false, Default: "false"},
+			{Name: "key-file", Description: "Encrypt file with the 32-byte key in this file (base64 or hex)", Required: false, Default: ""},
 		},
 		Tips: []string{
-			"Large files are automatically chunked for better performance",
+			"Files over 8 MiB are uploaded in parallel chunks; rerun an interrupted upload to resume it",
 			"Use --compress for text files to save space",
-			"Encrypted files require the same key for retrieval",
+			"--encrypt reads the passphrase from PEERVAULT_PASSPHRASE or prompts for it; the node never sees it",
+			"Encrypted files require the same key or passphrase for retrieval",
 		},
 		Related: []string{"get", "list", "delete"},
 	}
@@ -111,20 +115,21 @@ func (hm *HelpManager) initializeHelp() {
 	hm.commands["get"] = &CommandHelp{
 		Name:        "get",
 		Description: "Retrieve a file from the PeerVault network",
-		Usage:       "get <file_id> [output_path]",
+		Usage:       "get <file_id> [output_path] [--parallel N] [--key-file FILE]",
 		Examples: []string{
 			"get abc123def456",
 			"get abc123def456 ./downloaded-file.pdf",
-			"get abc123def456 --decrypt --key mykey",
+			"get abc123def456 ./photo.jpg --key-file ~/.peervault/photos.key",
+			"get abc123def456 ./dataset.tar --parallel 8",
 		},
 		Options: []*Option{
-			{Name: "decrypt", Short: "d", Description: "Decrypt file after downloading", Required: false, Default: "false"},
-			{Name: "key", Short: "k", Description: "Decryption key to use", Required: false, Default: ""},
+			{Name: "parallel", Description: "Number of chunks downloaded at once", Required: false, Default: "4"},
+			{Name: "key-file", Description: "Decrypt file with the key in this file", Required: false, Default: ""},
 		},
 		Tips: []string{
 			"If no output path is specified, file is saved with original name",
-			"Use --decrypt if the file was encrypted during storage",
-			"Download progress is shown for large files",
+			"Files encrypted on the client are decrypted after download; a passphrase is prompted for unless PEERVAULT_PASSPHRASE is set",
+			"Download progress is shown for large files; rerun an interrupted download to resume it",
 		},
 		Related: []string{"store", "list"},
 	}
@@ -183,17 +188,30 @@ func (hm *HelpManager) initializeHelp() {
 			{Name: "start", Description: "Start monitoring", Usage: "monitor start [interval]"},
 			{Name: "stop", Description: "Stop monitoring", Usage: "monitor stop"},
 			{Name: "status", Description: "Show monitoring status", Usage: "monitor status"},
-			{Name: "alerts", Description: "Show active alerts", Usage: "monitor alerts"},
+			{Name: "alerts", Description: "Show the server's alerts, or the local ones", Usage: "monitor alerts"},
+			{Name: "alerts rule", Description: "Create or replace a server alert rule", Usage: "monitor alerts rule <name> metric=<metric> [kind=threshold|absence|rate] [op=<op>] [threshold=<n>] [window=<duration>] [for=<duration>] [severity=<severity>] [channels=<a,b>]"},
+			{Name: "alerts rules", Description: "List server alert rules", Usage: "monitor alerts rules"},
+			{Name: "alerts delete-rule", Description: "Delete a server alert rule", Usage: "monitor alerts delete-rule <name>"},
+			{Name: "alerts channel", Description: "Create or replace a notification channel", Usage: "monitor alerts channel <name> <webhook|slack|email> <url|address>..."},
+			{Name: "alerts channels", Description: "List notification channels", Usage: "monitor alerts channels"},
+			{Name: "alerts delete-channel", Description: "Delete a notification channel", Usage: "monitor alerts delete-channel <name>"},
+			{Name: "alerts silence", Description: "Silence a rule, or every rule with *", Usage: "monitor alerts silence <rule|*> <duration> [comment]"},
+			{Name: "alerts silences", Description: "List silences", Usage: "monitor alerts silences"},
+			{Name: "alerts unsilence", Description: "End a silence early", Usage: "monitor alerts unsilence <id>"},
 			{Name: "dashboard", Description: "Show system dashboard", Usage: "monitor dashboard"},
 		},
 		Examples: []string{
 			"monitor start 30s",
 			"monitor dashboard",
 			"monitor alerts",
+			"monitor alerts channel ops slack https://hooks.slack.com/services/T000/B000/XXX",
+			"monitor alerts rule no-peers metric=peers op=< threshold=1 for=5m severity=critical channels=ops",
+			"monitor alerts silence no-peers 2h maintenance",
 		},
 		Tips: []string{
 			"Monitoring runs in the background",
 			"Alerts are shown in real-time",
+			"Server alert rules keep being evaluated when the CLI exits",
 			"Dashboard provides system overview",
 		},
 		Related: []string{"health", "metrics", "status"},
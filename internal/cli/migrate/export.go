@@ -0,0 +1,284 @@
+package migrate
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+)
+
+// ExportFilter selects the files to export
+type ExportFilter struct {
+	// Tags must all be carried by an exported file
+	Tags []string
+	// Prefix restricts the export to paths under it
+	Prefix string
+}
+
+// Export downloads the selected files of the cluster into dest. A catalog
+// is written first so an import can restore names, tags and metadata.
+func Export(ctx context.Context, cluster Cluster, dest Location, filter ExportFilter, opts Options) (*Report, error) {
+	var list *client.FileListResponse
+	var err error
+	if len(filter.Tags) > 0 {
+		list, err = cluster.SearchFiles(ctx, filter.Tags, nil)
+	} else {
+		list, err = cluster.ListFiles(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing files: %w", err)
+	}
+	catalog := buildCatalog(list.Files, filter.Prefix)
+
+	ex := &exporter{cluster: cluster, opts: opts, tracker: newTracker(opts.OnProgress)}
+	ex.tracker.setTotal(len(catalog.Files))
+	if dest.Kind == KindTar {
+		// A tar archive is written in one go, so there is nothing to resume
+		err = ex.toTar(ctx, dest, catalog)
+	} else {
+		err = ex.toTarget(ctx, dest, catalog)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ex.tracker.report(), nil
+}
+
+// buildCatalog assigns every file a path: where it was imported from if
+// that's known and unique, else its name, else its key and name. Keys are
+// sorted first so repeated exports choose the same paths.
+func buildCatalog(files []client.FileInfo, prefix string) *Catalog {
+	sorted := append([]client.FileInfo(nil), files...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+
+	counts := make(map[string]int)
+	for _, f := range sorted {
+		byPath, byName := validPath(f.Metadata[PathAttribute]), validPath(f.Name)
+		if byPath != "" {
+			counts[byPath]++
+		}
+		if byName != "" && byName != byPath {
+			counts[byName]++
+		}
+	}
+
+	catalog := &Catalog{ExportedAt: time.Now().UTC(), Files: []CatalogEntry{}}
+	taken := make(map[string]bool)
+	for _, f := range sorted {
+		var p string
+		for _, candidate := range []string{validPath(f.Metadata[PathAttribute]), validPath(f.Name)} {
+			if candidate != "" && counts[candidate] == 1 && !taken[candidate] {
+				p = candidate
+				break
+			}
+		}
+		if p == "" {
+			p = f.Key
+			if name := path.Base(validPath(f.Name)); name != "." && name != "" {
+				p = f.Key + "-" + name
+			}
+		}
+		taken[p] = true
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		catalog.Files = append(catalog.Files, CatalogEntry{
+			Path:        p,
+			Key:         f.Key,
+			Name:        f.Name,
+			ContentType: f.ContentType,
+			Size:        f.Size,
+			Hash:        f.Hash,
+			Tags:        f.Tags,
+			Metadata:    f.Metadata,
+			CreatedAt:   f.CreatedAt,
+		})
+	}
+	return catalog
+}
+
+// validPath returns p if it is a clean relative path that can be written
+// to a directory, bucket or archive
+func validPath(p string) string {
+	if p == "" || p == CatalogName || path.IsAbs(p) || path.Clean(p) != p || p == ".." || strings.HasPrefix(p, "../") {
+		return ""
+	}
+	return p
+}
+
+type exporter struct {
+	cluster Cluster
+	opts    Options
+	tracker *tracker
+}
+
+func (ex *exporter) toTarget(ctx context.Context, dest Location, catalog *Catalog) error {
+	target, err := dest.target(ex.opts.S3)
+	if err != nil {
+		return err
+	}
+	j, err := openJournal(ex.opts.Journal, "export "+dest.String())
+	if err != nil {
+		return err
+	}
+	defer j.close()
+
+	encoded, err := json.MarshalIndent(catalog, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := target.Put(ctx, CatalogName, bytes.NewReader(encoded), int64(len(encoded))); err != nil {
+		return fmt.Errorf("writing %s: %w", CatalogName, err)
+	}
+
+	ex.work(ctx, catalog, func(entry CatalogEntry) {
+		if j.completed(entry.Key) {
+			ex.tracker.skip(entry.Path)
+			return
+		}
+		data, err := ex.download(ctx, entry, func(data []byte) error {
+			return target.Put(ctx, entry.Path, bytes.NewReader(data), int64(len(data)))
+		})
+		if err == nil {
+			err = j.record(entry.Key, entry.Key, int64(len(data)))
+		}
+		ex.finish(entry, data, err)
+	})
+	return ctx.Err()
+}
+
+func (ex *exporter) toTar(ctx context.Context, dest Location, catalog *Catalog) error {
+	partial := dest.Path + ".partial"
+	f, err := os.Create(partial)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(partial)
+	defer f.Close()
+
+	var w io.Writer = f
+	var gz *gzip.Writer
+	if dest.compressed() {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+
+	encoded, err := json.MarshalIndent(catalog, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, CatalogName, catalog.ExportedAt, encoded); err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	ex.work(ctx, catalog, func(entry CatalogEntry) {
+		data, err := ex.download(ctx, entry, nil)
+		if err == nil {
+			mu.Lock()
+			err = writeTarFile(tw, entry.Path, entry.CreatedAt, data)
+			mu.Unlock()
+		}
+		ex.finish(entry, data, err)
+	})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(partial, dest.Path)
+}
+
+func writeTarFile(tw *tar.Writer, name string, modTime time.Time, data []byte) error {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// work hands every catalog entry to the configured number of workers
+func (ex *exporter) work(ctx context.Context, catalog *Catalog, fn func(CatalogEntry)) {
+	entries := make(chan CatalogEntry)
+	var wg sync.WaitGroup
+	for i := 0; i < ex.opts.workers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range entries {
+				fn(entry)
+			}
+		}()
+	}
+	for _, entry := range catalog.Files {
+		select {
+		case entries <- entry:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(entries)
+	wg.Wait()
+}
+
+// download fetches and verifies a file, then passes it to store if given
+func (ex *exporter) download(ctx context.Context, entry CatalogEntry, store func([]byte) error) ([]byte, error) {
+	var data []byte
+	err := retry(ctx, ex.opts.Retries, func() error {
+		var buf bytes.Buffer
+		if _, err := ex.cluster.DownloadContent(ctx, entry.Key, &buf); err != nil {
+			return err
+		}
+		if entry.Hash != "" {
+			if sum := fmt.Sprintf("%x", sha256.Sum256(buf.Bytes())); sum != entry.Hash {
+				return fmt.Errorf("downloaded hash %s does not match stored hash %s", sum, entry.Hash)
+			}
+		}
+		data = buf.Bytes()
+		if store != nil {
+			return store(data)
+		}
+		return nil
+	})
+	return data, err
+}
+
+func (ex *exporter) finish(entry CatalogEntry, data []byte, err error) {
+	if err != nil {
+		ex.tracker.fail(entry.Path, err)
+		return
+	}
+	ex.tracker.done(entry.Path, int64(len(data)))
+}
@@ -0,0 +1,335 @@
+package migrate
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Skpow1234/Peervault/internal/backup"
+)
+
+// importItem is one file to upload. Files from a directory or bucket are
+// opened by the worker; tar members are read ahead since a tar stream can
+// only be walked once.
+type importItem struct {
+	path string
+	data []byte
+}
+
+// Import uploads every file of src into the cluster
+func Import(ctx context.Context, cluster Cluster, src Location, opts Options) (*Report, error) {
+	j, err := openJournal(opts.Journal, "import "+src.String())
+	if err != nil {
+		return nil, err
+	}
+	defer j.close()
+
+	im := &importer{cluster: cluster, opts: opts, journal: j, tracker: newTracker(opts.OnProgress)}
+	switch src.Kind {
+	case KindTar:
+		err = im.fromTar(ctx, src)
+	default:
+		err = im.fromTarget(ctx, src)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return im.tracker.report(), nil
+}
+
+type importer struct {
+	cluster Cluster
+	opts    Options
+	journal *journal
+	tracker *tracker
+	target  backup.Target
+
+	// catalog holds the attributes of an exported dataset by path
+	catalog map[string]CatalogEntry
+}
+
+func (im *importer) fromTarget(ctx context.Context, src Location) error {
+	target, err := src.target(im.opts.S3)
+	if err != nil {
+		return err
+	}
+	if src.Kind == KindDir {
+		if info, err := os.Stat(src.Path); err != nil {
+			return err
+		} else if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", src.Path)
+		}
+	}
+	im.target = target
+
+	paths, err := target.List(ctx, "")
+	if err != nil {
+		return fmt.Errorf("listing %s: %w", src, err)
+	}
+	if err := im.loadCatalog(ctx, paths); err != nil {
+		return err
+	}
+
+	// A journal kept inside the source directory is not part of the data
+	journalPath := journalWithin(src, im.opts.Journal)
+	var files []string
+	for _, p := range paths {
+		if p != CatalogName && p != journalPath && !strings.HasSuffix(p, "/") {
+			files = append(files, p)
+		}
+	}
+	im.tracker.setTotal(len(files))
+
+	items := make(chan importItem)
+	go func() {
+		defer close(items)
+		for _, p := range files {
+			select {
+			case items <- importItem{path: p}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	im.work(ctx, items)
+	return ctx.Err()
+}
+
+// journalWithin returns the journal's path relative to a source directory,
+// or "" if it lies elsewhere
+func journalWithin(src Location, journal string) string {
+	if src.Kind != KindDir || journal == "" {
+		return ""
+	}
+	root, err := filepath.Abs(src.Path)
+	if err != nil {
+		return ""
+	}
+	abs, err := filepath.Abs(journal)
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+func (im *importer) loadCatalog(ctx context.Context, paths []string) error {
+	for _, p := range paths {
+		if p != CatalogName {
+			continue
+		}
+		rc, err := im.target.Get(ctx, CatalogName)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return im.parseCatalog(rc)
+	}
+	return nil
+}
+
+func (im *importer) parseCatalog(r io.Reader) error {
+	var catalog Catalog
+	if err := json.NewDecoder(r).Decode(&catalog); err != nil {
+		return fmt.Errorf("reading %s: %w", CatalogName, err)
+	}
+	im.catalog = make(map[string]CatalogEntry, len(catalog.Files))
+	for _, entry := range catalog.Files {
+		im.catalog[entry.Path] = entry
+	}
+	return nil
+}
+
+// fromTar walks the archive once. The catalog is honoured only as the
+// first member, which is where exports put it.
+func (im *importer) fromTar(ctx context.Context, src Location) error {
+	f, err := os.Open(src.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if src.compressed() {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", src, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+
+	items := make(chan importItem)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(items)
+		first := true
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				readErr <- nil
+				return
+			}
+			if err != nil {
+				readErr <- fmt.Errorf("reading %s: %w", src, err)
+				return
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+			if name == CatalogName {
+				if first {
+					if err := im.parseCatalog(tr); err != nil {
+						readErr <- err
+						return
+					}
+					im.tracker.setTotal(len(im.catalog))
+				}
+				first = false
+				continue
+			}
+			first = false
+			if im.journal.completed(name) {
+				im.tracker.skip(name)
+				continue
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				readErr <- fmt.Errorf("reading %s from %s: %w", name, src, err)
+				return
+			}
+			select {
+			case items <- importItem{path: name, data: data}:
+			case <-ctx.Done():
+				readErr <- ctx.Err()
+				return
+			}
+		}
+	}()
+	im.work(ctx, items)
+	return <-readErr
+}
+
+// work uploads items with the configured number of workers
+func (im *importer) work(ctx context.Context, items <-chan importItem) {
+	var wg sync.WaitGroup
+	for i := 0; i < im.opts.workers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				im.upload(ctx, item)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (im *importer) upload(ctx context.Context, item importItem) {
+	if im.journal.completed(item.path) {
+		im.tracker.skip(item.path)
+		return
+	}
+	name, contentType, tags, metadata := im.attributes(item.path)
+
+	var key string
+	var size int64
+	err := retry(ctx, im.opts.Retries, func() error {
+		r, err := im.open(ctx, item)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		hash := sha256.New()
+		counted := &countingReader{r: io.TeeReader(r, hash)}
+		info, err := im.cluster.UploadData(ctx, name, contentType, counted, tags, metadata)
+		if err != nil {
+			return err
+		}
+		if sum := fmt.Sprintf("%x", hash.Sum(nil)); info.Hash != "" && info.Hash != sum {
+			return fmt.Errorf("stored hash %s does not match source hash %s", info.Hash, sum)
+		}
+		key, size = info.Key, counted.n
+		return nil
+	})
+	if err == nil {
+		err = im.journal.record(item.path, key, size)
+	}
+	if err != nil {
+		im.tracker.fail(item.path, err)
+		return
+	}
+	im.tracker.done(item.path, size)
+}
+
+func (im *importer) open(ctx context.Context, item importItem) (io.ReadCloser, error) {
+	if item.data != nil {
+		return io.NopCloser(bytes.NewReader(item.data)), nil
+	}
+	return im.target.Get(ctx, item.path)
+}
+
+// attributes restores what the catalog recorded for a file. Files without
+// a catalog entry remember their source path so an export can put them
+// back in the same place.
+func (im *importer) attributes(p string) (name, contentType string, tags []string, metadata map[string]string) {
+	if entry, ok := im.catalog[p]; ok {
+		name = entry.Name
+		if name == "" {
+			name = path.Base(p)
+		}
+		metadata = make(map[string]string, len(entry.Metadata))
+		for k, v := range entry.Metadata {
+			metadata[k] = v
+		}
+		return name, entry.ContentType, mergeTags(entry.Tags, im.opts.Tags), metadata
+	}
+	return path.Base(p), mime.TypeByExtension(path.Ext(p)), mergeTags(nil, im.opts.Tags), map[string]string{PathAttribute: p}
+}
+
+func mergeTags(tags, extra []string) []string {
+	merged := append([]string(nil), tags...)
+	for _, tag := range extra {
+		if !containsTag(merged, tag) {
+			merged = append(merged, tag)
+		}
+	}
+	return merged
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,86 @@
+package migrate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/backup"
+)
+
+// Kind is the type of an import source or export destination
+type Kind string
+
+const (
+	KindDir Kind = "dir"
+	KindTar Kind = "tar"
+	KindS3  Kind = "s3"
+)
+
+// Location is an import source or export destination
+type Location struct {
+	Kind Kind
+	// Path is the directory or archive of a dir or tar location
+	Path   string
+	Bucket string
+	Prefix string
+	raw    string
+}
+
+// S3Options configures access to S3 locations. Credentials come from
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+type S3Options struct {
+	Region string
+	// Endpoint selects an S3-compatible store instead of AWS
+	Endpoint string
+}
+
+// ParseLocation parses a directory path, a .tar/.tar.gz/.tgz archive path
+// or an s3://bucket/prefix URL
+func ParseLocation(s string) (Location, error) {
+	if s == "" {
+		return Location{}, fmt.Errorf("location is required")
+	}
+	if rest, ok := strings.CutPrefix(s, "s3://"); ok {
+		bucket, prefix, _ := strings.Cut(rest, "/")
+		if bucket == "" {
+			return Location{}, fmt.Errorf("invalid S3 location %q, want s3://bucket/prefix", s)
+		}
+		return Location{Kind: KindS3, Bucket: bucket, Prefix: prefix, raw: s}, nil
+	}
+	if isTar(s) {
+		return Location{Kind: KindTar, Path: s, raw: s}, nil
+	}
+	return Location{Kind: KindDir, Path: s, raw: s}, nil
+}
+
+func isTar(s string) bool {
+	return strings.HasSuffix(s, ".tar") || strings.HasSuffix(s, ".tar.gz") || strings.HasSuffix(s, ".tgz")
+}
+
+// compressed reports whether a tar location is gzipped
+func (l Location) compressed() bool {
+	return strings.HasSuffix(l.Path, ".gz") || strings.HasSuffix(l.Path, ".tgz")
+}
+
+func (l Location) String() string {
+	return l.raw
+}
+
+// target opens a dir or S3 location with the backup targets, which already
+// provide atomic writes and S3 request signing
+func (l Location) target(opts S3Options) (backup.Target, error) {
+	switch l.Kind {
+	case KindDir:
+		return backup.NewDirTarget(l.Path), nil
+	case KindS3:
+		return backup.NewS3Target(backup.TargetConfig{
+			Type:     "s3",
+			Bucket:   l.Bucket,
+			Prefix:   l.Prefix,
+			Region:   opts.Region,
+			Endpoint: opts.Endpoint,
+		})
+	default:
+		return nil, fmt.Errorf("%s is not a directory or S3 location", l)
+	}
+}
@@ -0,0 +1,284 @@
+// Package migrate moves large datasets into and out of a PeerVault cluster.
+// Directories, tar archives and S3 buckets are copied by parallel workers,
+// a journal of completed files lets an interrupted run resume, and file
+// names, content types, tags and metadata survive the round trip.
+package migrate
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+)
+
+const (
+	// CatalogName is the file an export writes first, describing every
+	// exported file; imports read it back to restore attributes
+	CatalogName = "peervault-export.json"
+	// PathAttribute is the metadata attribute recording where an imported
+	// file came from; exports use it to put the file back at that path
+	PathAttribute = "import_path"
+)
+
+// Cluster is the part of the API client used by imports and exports
+type Cluster interface {
+	UploadData(ctx context.Context, name, contentType string, r io.Reader, tags []string, metadata map[string]string) (*client.FileInfo, error)
+	ListFiles(ctx context.Context) (*client.FileListResponse, error)
+	SearchFiles(ctx context.Context, tags []string, metadata map[string]string) (*client.FileListResponse, error)
+	DownloadContent(ctx context.Context, key string, w io.Writer) (int64, error)
+}
+
+// Options tunes an import or export
+type Options struct {
+	// Workers is the number of files transferred in parallel
+	Workers int
+	// Retries is how many more times a failed file is attempted
+	Retries int
+	// Journal records completed files so a rerun skips them; empty
+	// disables resuming
+	Journal string
+	// Tags are added to every imported file
+	Tags []string
+	S3   S3Options
+	// OnProgress is called after every file; calls are serialised
+	OnProgress func(Progress)
+}
+
+// Progress counts the files handled so far
+type Progress struct {
+	// Total is 0 while unknown, e.g. for a tar archive without a catalog
+	Total   int   `json:"total"`
+	Done    int   `json:"done"`
+	Skipped int   `json:"skipped"`
+	Failed  int   `json:"failed"`
+	Bytes   int64 `json:"bytes"`
+	// Current is the file handled last
+	Current string `json:"current,omitempty"`
+}
+
+// Failure is a file that could not be transferred
+type Failure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// Report summarises a finished import or export
+type Report struct {
+	Progress
+	Failures []Failure     `json:"failures,omitempty"`
+	Elapsed  time.Duration `json:"elapsed"`
+}
+
+// CatalogEntry describes one exported file
+type CatalogEntry struct {
+	Path        string            `json:"path"`
+	Key         string            `json:"key"`
+	Name        string            `json:"name"`
+	ContentType string            `json:"content_type,omitempty"`
+	Size        int64             `json:"size"`
+	Hash        string            `json:"hash,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// Catalog lists the files of an export
+type Catalog struct {
+	ExportedAt time.Time      `json:"exported_at"`
+	Files      []CatalogEntry `json:"files"`
+}
+
+// tracker accumulates progress from concurrent workers
+type tracker struct {
+	mu         sync.Mutex
+	progress   Progress
+	failures   []Failure
+	onProgress func(Progress)
+	started    time.Time
+}
+
+func newTracker(onProgress func(Progress)) *tracker {
+	return &tracker{onProgress: onProgress, started: time.Now()}
+}
+
+func (t *tracker) setTotal(total int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.progress.Total = total
+}
+
+func (t *tracker) done(path string, size int64) {
+	t.update(path, func(p *Progress) {
+		p.Done++
+		p.Bytes += size
+	})
+}
+
+func (t *tracker) skip(path string) {
+	t.update(path, func(p *Progress) { p.Skipped++ })
+}
+
+func (t *tracker) fail(path string, err error) {
+	t.update(path, func(p *Progress) {
+		p.Failed++
+		t.failures = append(t.failures, Failure{Path: path, Error: err.Error()})
+	})
+}
+
+func (t *tracker) update(path string, fn func(*Progress)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn(&t.progress)
+	t.progress.Current = path
+	if t.onProgress != nil {
+		t.onProgress(t.progress)
+	}
+}
+
+func (t *tracker) report() *Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &Report{Progress: t.progress, Failures: t.failures, Elapsed: time.Since(t.started)}
+}
+
+// journal is an append-only record of completed files. One JSON object per
+// line keeps appends cheap and survives a crash mid-write: a torn last line
+// is ignored and that file is simply transferred again.
+type journal struct {
+	mu   sync.Mutex
+	f    *os.File
+	done map[string]bool
+}
+
+type journalHeader struct {
+	Location string `json:"location"`
+}
+
+type journalEntry struct {
+	ID   string `json:"id"`
+	Key  string `json:"key,omitempty"`
+	Size int64  `json:"size"`
+}
+
+// openJournal loads the journal at path, creating it for location if it
+// doesn't exist. A nil journal (empty path) records nothing.
+func openJournal(path, location string) (*journal, error) {
+	if path == "" {
+		return nil, nil
+	}
+	j := &journal{done: make(map[string]bool)}
+
+	data, err := os.Open(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		scanner := bufio.NewScanner(data)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		first := true
+		for scanner.Scan() {
+			if first {
+				first = false
+				var header journalHeader
+				if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Location != location {
+					data.Close()
+					return nil, fmt.Errorf("journal %s belongs to a different transfer (%s); remove it or pass another journal", path, header.Location)
+				}
+				continue
+			}
+			var entry journalEntry
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.ID != "" {
+				j.done[entry.ID] = true
+			}
+		}
+		data.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("reading journal %s: %w", path, err)
+		}
+		if !first {
+			j.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+			if err != nil {
+				return nil, err
+			}
+			return j, nil
+		}
+	}
+
+	j.f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := j.write(journalHeader{Location: location}); err != nil {
+		j.f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *journal) completed(id string) bool {
+	if j == nil {
+		return false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.done[id]
+}
+
+func (j *journal) record(id, key string, size int64) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.done[id] = true
+	return j.write(journalEntry{ID: id, Key: key, Size: size})
+}
+
+func (j *journal) write(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = j.f.Write(append(line, '\n'))
+	return err
+}
+
+func (j *journal) close() error {
+	if j == nil {
+		return nil
+	}
+	return j.f.Close()
+}
+
+// retry runs fn up to 1+retries times, backing off between attempts
+func retry(ctx context.Context, retries int, fn func() error) error {
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * 500 * time.Millisecond):
+			}
+		}
+		if err = fn(); err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (o *Options) workers() int {
+	if o.Workers <= 0 {
+		return 4
+	}
+	return o.Workers
+}
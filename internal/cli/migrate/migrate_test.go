@@ -0,0 +1,326 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+)
+
+// memCluster is an in-memory stand-in for the REST API
+type memCluster struct {
+	mu      sync.Mutex
+	files   map[string]client.FileInfo
+	content map[string][]byte
+	next    int
+	// failUploads fails that many uploads before succeeding
+	failUploads int
+}
+
+func newMemCluster() *memCluster {
+	return &memCluster{files: make(map[string]client.FileInfo), content: make(map[string][]byte)}
+}
+
+func (c *memCluster) UploadData(ctx context.Context, name, contentType string, r io.Reader, tags []string, metadata map[string]string) (*client.FileInfo, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failUploads > 0 {
+		c.failUploads--
+		return nil, errors.New("upload failed 503: unavailable")
+	}
+	c.next++
+	info := client.FileInfo{
+		Key:         fmt.Sprintf("file_%03d", c.next),
+		Name:        name,
+		Size:        int64(len(data)),
+		ContentType: contentType,
+		Hash:        fmt.Sprintf("%x", sha256.Sum256(data)),
+		CreatedAt:   time.Now(),
+		Tags:        tags,
+		Metadata:    metadata,
+	}
+	info.ID = info.Key
+	c.files[info.Key] = info
+	c.content[info.Key] = data
+	return &info, nil
+}
+
+func (c *memCluster) ListFiles(ctx context.Context) (*client.FileListResponse, error) {
+	return c.SearchFiles(ctx, nil, nil)
+}
+
+func (c *memCluster) SearchFiles(ctx context.Context, tags []string, metadata map[string]string) (*client.FileListResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := &client.FileListResponse{Files: []client.FileInfo{}}
+	for _, info := range c.files {
+		matches := true
+		for _, tag := range tags {
+			matches = matches && containsTag(info.Tags, tag)
+		}
+		if matches {
+			list.Files = append(list.Files, info)
+		}
+	}
+	list.Total = len(list.Files)
+	return list, nil
+}
+
+func (c *memCluster) DownloadContent(ctx context.Context, key string, w io.Writer) (int64, error) {
+	c.mu.Lock()
+	data, ok := c.content[key]
+	c.mu.Unlock()
+	if !ok {
+		return 0, errors.New("download failed 404: not found")
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// byPath indexes the cluster's files by their import path
+func (c *memCluster) byPath() map[string]client.FileInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	files := make(map[string]client.FileInfo)
+	for _, info := range c.files {
+		files[info.Metadata[PathAttribute]] = info
+	}
+	return files
+}
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for p, content := range files {
+		full := filepath.Join(root, filepath.FromSlash(p))
+		require.NoError(t, os.MkdirAll(filepath.Dir(full), 0755))
+		require.NoError(t, os.WriteFile(full, []byte(content), 0644))
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	loc, err := ParseLocation("s3://bucket/exports/2026")
+	require.NoError(t, err)
+	assert.Equal(t, KindS3, loc.Kind)
+	assert.Equal(t, "bucket", loc.Bucket)
+	assert.Equal(t, "exports/2026", loc.Prefix)
+
+	loc, err = ParseLocation("data.tgz")
+	require.NoError(t, err)
+	assert.Equal(t, KindTar, loc.Kind)
+	assert.True(t, loc.compressed())
+
+	loc, err = ParseLocation("data.tar")
+	require.NoError(t, err)
+	assert.Equal(t, KindTar, loc.Kind)
+	assert.False(t, loc.compressed())
+
+	loc, err = ParseLocation("./data")
+	require.NoError(t, err)
+	assert.Equal(t, KindDir, loc.Kind)
+
+	_, err = ParseLocation("s3://")
+	assert.Error(t, err)
+	_, err = ParseLocation("")
+	assert.Error(t, err)
+}
+
+func TestImport_Directory(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"a.txt":         "alpha",
+		"docs/b.json":   `{"b":1}`,
+		"docs/deep/c.x": "gamma",
+	})
+	cluster := newMemCluster()
+	loc, err := ParseLocation(src)
+	require.NoError(t, err)
+
+	var updates int
+	report, err := Import(context.Background(), cluster, loc, Options{
+		Workers:    2,
+		Tags:       []string{"migrated"},
+		OnProgress: func(Progress) { updates++ },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Total)
+	assert.Equal(t, 3, report.Done)
+	assert.Equal(t, int64(len("alpha")+len(`{"b":1}`)+len("gamma")), report.Bytes)
+	assert.Empty(t, report.Failures)
+	assert.Equal(t, 3, updates)
+
+	files := cluster.byPath()
+	require.Len(t, files, 3)
+	assert.Equal(t, "b.json", files["docs/b.json"].Name)
+	assert.Equal(t, "application/json", files["docs/b.json"].ContentType)
+	assert.Equal(t, []string{"migrated"}, files["docs/deep/c.x"].Tags)
+}
+
+func TestImport_ResumesFromJournal(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"a": "1", "b": "2", "c": "3"})
+	journalPath := filepath.Join(src, ".journal.jsonl")
+	loc, err := ParseLocation(src)
+	require.NoError(t, err)
+
+	// The only attempt for one file fails, leaving it for the next run
+	cluster := newMemCluster()
+	cluster.failUploads = 1
+	report, err := Import(context.Background(), cluster, loc, Options{Workers: 1, Journal: journalPath})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Done)
+	require.Len(t, report.Failures, 1)
+	failed := report.Failures[0].Path
+
+	report, err = Import(context.Background(), cluster, loc, Options{Workers: 1, Journal: journalPath})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Done)
+	assert.Equal(t, 2, report.Skipped)
+	assert.Equal(t, 3, report.Total, "the journal itself is not imported")
+	assert.Contains(t, cluster.byPath(), failed)
+	assert.Len(t, cluster.byPath(), 3, "nothing was uploaded twice")
+
+	// A journal is tied to its source
+	other, err := ParseLocation(t.TempDir())
+	require.NoError(t, err)
+	_, err = Import(context.Background(), cluster, other, Options{Journal: journalPath})
+	assert.Error(t, err)
+}
+
+func TestImport_RetriesFailedUploads(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"a": "1"})
+	loc, err := ParseLocation(src)
+	require.NoError(t, err)
+
+	cluster := newMemCluster()
+	cluster.failUploads = 1
+	report, err := Import(context.Background(), cluster, loc, Options{Retries: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Done)
+	assert.Empty(t, report.Failures)
+}
+
+func TestExportImport_RoundTrip(t *testing.T) {
+	for _, dest := range []string{"out", "out.tar", "out.tar.gz"} {
+		t.Run(dest, func(t *testing.T) {
+			src := t.TempDir()
+			writeTree(t, src, map[string]string{"a.txt": "alpha", "nested/b.bin": "beta"})
+			loc, err := ParseLocation(src)
+			require.NoError(t, err)
+
+			origin := newMemCluster()
+			_, err = Import(context.Background(), origin, loc, Options{Tags: []string{"keep"}})
+			require.NoError(t, err)
+			// Attributes added after the import survive too
+			origin.mu.Lock()
+			for key, info := range origin.files {
+				info.Metadata["owner"] = "team-a"
+				origin.files[key] = info
+			}
+			origin.mu.Unlock()
+
+			out, err := ParseLocation(filepath.Join(t.TempDir(), dest))
+			require.NoError(t, err)
+			report, err := Export(context.Background(), origin, out, ExportFilter{}, Options{Workers: 3})
+			require.NoError(t, err)
+			assert.Equal(t, 2, report.Done)
+			assert.Empty(t, report.Failures)
+
+			if out.Kind == KindDir {
+				data, err := os.ReadFile(filepath.Join(out.Path, "nested", "b.bin"))
+				require.NoError(t, err)
+				assert.Equal(t, "beta", string(data))
+			} else {
+				_, err := os.Stat(out.Path + ".partial")
+				assert.True(t, os.IsNotExist(err))
+			}
+
+			restored := newMemCluster()
+			report, err = Import(context.Background(), restored, out, Options{})
+			require.NoError(t, err)
+			assert.Equal(t, 2, report.Done)
+
+			files := restored.byPath()
+			require.Len(t, files, 2)
+			b := files["nested/b.bin"]
+			assert.Equal(t, "b.bin", b.Name)
+			assert.Equal(t, []string{"keep"}, b.Tags)
+			assert.Equal(t, "team-a", b.Metadata["owner"])
+			assert.Equal(t, "beta", string(restored.content[b.Key]))
+		})
+	}
+}
+
+func TestExport_ResumesAndFilters(t *testing.T) {
+	cluster := newMemCluster()
+	ctx := context.Background()
+	_, err := cluster.UploadData(ctx, "one", "", bytes.NewReader([]byte("1")), []string{"x"}, map[string]string{PathAttribute: "set/one"})
+	require.NoError(t, err)
+	_, err = cluster.UploadData(ctx, "two", "", bytes.NewReader([]byte("2")), []string{"x"}, map[string]string{PathAttribute: "other/two"})
+	require.NoError(t, err)
+	_, err = cluster.UploadData(ctx, "three", "", bytes.NewReader([]byte("3")), nil, map[string]string{PathAttribute: "set/three"})
+	require.NoError(t, err)
+
+	out, err := ParseLocation(t.TempDir())
+	require.NoError(t, err)
+	journalPath := filepath.Join(t.TempDir(), "export.jsonl")
+
+	report, err := Export(ctx, cluster, out, ExportFilter{Tags: []string{"x"}, Prefix: "set/"}, Options{Journal: journalPath})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Total)
+	assert.Equal(t, 1, report.Done)
+
+	report, err = Export(ctx, cluster, out, ExportFilter{Prefix: "set/"}, Options{Journal: journalPath})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Total)
+	assert.Equal(t, 1, report.Done)
+	assert.Equal(t, 1, report.Skipped)
+
+	_, err = os.Stat(filepath.Join(out.Path, "other", "two"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestBuildCatalog_Paths(t *testing.T) {
+	files := []client.FileInfo{
+		{Key: "k3", Name: "report.pdf"},
+		{Key: "k1", Name: "report.pdf"},
+		{Key: "k2", Name: "notes.txt", Metadata: map[string]string{PathAttribute: "2026/notes.txt"}},
+		{Key: "k4", Name: "../escape", Metadata: map[string]string{PathAttribute: "/abs"}},
+		{Key: "k5", Name: "a.txt", Metadata: map[string]string{PathAttribute: "a.txt"}},
+	}
+	catalog := buildCatalog(files, "")
+	paths := make(map[string]string)
+	for _, entry := range catalog.Files {
+		paths[entry.Key] = entry.Path
+	}
+	assert.Equal(t, map[string]string{
+		"k1": "k1-report.pdf",
+		"k2": "2026/notes.txt",
+		"k3": "k3-report.pdf",
+		"k4": "k4",
+		"k5": "a.txt",
+	}, paths)
+
+	keys := make([]string, 0, len(catalog.Files))
+	for _, entry := range catalog.Files {
+		keys = append(keys, entry.Key)
+	}
+	assert.True(t, sort.StringsAreSorted(keys))
+}
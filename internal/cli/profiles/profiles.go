@@ -1,417 +1,220 @@
+// Package profiles manages named server connections. Profiles are kept in
+// the CLI config, and their tokens in the OS keyring.
 package profiles
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
-	"sync"
+	"regexp"
+	"sort"
+	"strings"
 
+	"github.com/Skpow1234/Peervault/internal/cli/client"
 	"github.com/Skpow1234/Peervault/internal/cli/config"
+	"github.com/Skpow1234/Peervault/internal/cli/secrets"
 )
 
-// Manager manages configuration profiles
+// ErrNotFound is returned for a profile name that isn't configured
+var ErrNotFound = errors.New("no such profile")
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Manager adds, removes and selects connection profiles
 type Manager struct {
-	profiles  map[string]*Profile
-	current   string
-	configDir string
-	mu        sync.RWMutex
+	cfg   *config.Config
+	store secrets.Store
 }
 
-// Profile represents a configuration profile
-type Profile struct {
-	Name        string                 `json:"name"`
-	Description string                 `json:"description"`
-	Config      *config.Config         `json:"config"`
-	Metadata    map[string]interface{} `json:"metadata"`
-	CreatedAt   string                 `json:"created_at"`
-	UpdatedAt   string                 `json:"updated_at"`
+// Connection is a resolved profile: where to connect and with what token
+type Connection struct {
+	Profile   string
+	ServerURL string
+	Token     string
+	TLS       *config.TLSConfig
 }
 
-// New creates a new profile manager
-func New(configDir string) *Manager {
-	pm := &Manager{
-		profiles:  make(map[string]*Profile),
-		configDir: configDir,
-	}
-
-	pm.initializeDefaultProfiles()
-	_ = pm.loadProfiles() // Ignore error for initialization
-	return pm
+// New creates a manager for the profiles in cfg, which is saved after
+// every change, with tokens kept in store
+func New(cfg *config.Config, store secrets.Store) *Manager {
+	return &Manager{cfg: cfg, store: store}
 }
 
-// initializeDefaultProfiles creates default profiles
-func (pm *Manager) initializeDefaultProfiles() {
-	pm.mu.Lock()
-	defer pm.mu.Unlock()
-
-	// Development profile
-	pm.profiles["development"] = &Profile{
-		Name:        "development",
-		Description: "Development environment with local server",
-		Config: &config.Config{
-			ServerURL:    "http://localhost:8080",
-			AuthToken:    "",
-			OutputFormat: "table",
-			Theme:        "default",
-			Verbose:      true,
-			HistoryFile:  "history_dev.txt",
-		},
-		Metadata: map[string]interface{}{
-			"environment": "development",
-			"debug":       true,
-		},
-		CreatedAt: "2024-01-01T00:00:00Z",
-		UpdatedAt: "2024-01-01T00:00:00Z",
+// Add creates or replaces a profile. A non-empty token is stored in the
+// keyring; without one the profile has no token unless it names a
+// token file. The first profile added becomes the current one.
+func (m *Manager) Add(name string, profile *config.Profile, token string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q: use letters, digits, '.', '_' and '-'", name)
 	}
-
-	// Production profile
-	pm.profiles["production"] = &Profile{
-		Name:        "production",
-		Description: "Production environment with secure settings",
-		Config: &config.Config{
-			ServerURL:    "https://api.peervault.com",
-			AuthToken:    "",
-			OutputFormat: "json",
-			Theme:        "minimal",
-			Verbose:      false,
-			HistoryFile:  "history_prod.txt",
-		},
-		Metadata: map[string]interface{}{
-			"environment": "production",
-			"debug":       false,
-			"security":    "high",
-		},
-		CreatedAt: "2024-01-01T00:00:00Z",
-		UpdatedAt: "2024-01-01T00:00:00Z",
+	if err := validate(profile); err != nil {
+		return err
 	}
-
-	// Testing profile
-	pm.profiles["testing"] = &Profile{
-		Name:        "testing",
-		Description: "Testing environment with mock server",
-		Config: &config.Config{
-			ServerURL:    "http://localhost:3000",
-			AuthToken:    "test-token",
-			OutputFormat: "yaml",
-			Theme:        "test",
-			Verbose:      true,
-			HistoryFile:  "history_test.txt",
-		},
-		Metadata: map[string]interface{}{
-			"environment": "testing",
-			"debug":       true,
-			"mock":        true,
-		},
-		CreatedAt: "2024-01-01T00:00:00Z",
-		UpdatedAt: "2024-01-01T00:00:00Z",
+	if profile.TokenFile != "" && token != "" {
+		return fmt.Errorf("give either a token or a token file, not both")
 	}
 
-	// Set default current profile
-	pm.current = "development"
-}
-
-// CreateProfile creates a new profile
-func (pm *Manager) CreateProfile(name, description string, baseConfig *config.Config) error {
-	pm.mu.Lock()
-	defer pm.mu.Unlock()
-
-	if _, exists := pm.profiles[name]; exists {
-		return fmt.Errorf("profile '%s' already exists", name)
+	if token != "" {
+		if err := m.store.Set(secretKey(name), token); err != nil {
+			return fmt.Errorf("failed to store token: %w", err)
+		}
+	} else if err := m.store.Delete(secretKey(name)); err != nil {
+		return fmt.Errorf("failed to remove old token: %w", err)
 	}
 
-	profile := &Profile{
-		Name:        name,
-		Description: description,
-		Config:      baseConfig,
-		Metadata:    make(map[string]interface{}),
-		CreatedAt:   getCurrentTimestamp(),
-		UpdatedAt:   getCurrentTimestamp(),
+	if m.cfg.Profiles == nil {
+		m.cfg.Profiles = make(map[string]*config.Profile)
 	}
-
-	pm.profiles[name] = profile
-	return pm.saveProfile(profile)
+	m.cfg.Profiles[name] = profile
+	if m.cfg.Profile == "" {
+		m.cfg.Profile = name
+	}
+	return m.cfg.Save()
 }
 
-// DeleteProfile deletes a profile
-func (pm *Manager) DeleteProfile(name string) error {
-	pm.mu.Lock()
-	defer pm.mu.Unlock()
-
-	if _, exists := pm.profiles[name]; !exists {
-		return fmt.Errorf("profile '%s' does not exist", name)
+// Remove deletes a profile and its token. Removing the current profile
+// leaves none selected.
+func (m *Manager) Remove(name string) error {
+	if _, ok := m.cfg.Profiles[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
 	}
-
-	// Don't allow deleting the current profile
-	if pm.current == name {
-		return fmt.Errorf("cannot delete current profile '%s'", name)
+	if err := m.store.Delete(secretKey(name)); err != nil {
+		return fmt.Errorf("failed to remove token: %w", err)
 	}
-
-	delete(pm.profiles, name)
-	return pm.deleteProfileFile(name)
-}
-
-// SwitchProfile switches to a different profile
-func (pm *Manager) SwitchProfile(name string) error {
-	pm.mu.Lock()
-	defer pm.mu.Unlock()
-
-	if _, exists := pm.profiles[name]; !exists {
-		return fmt.Errorf("profile '%s' does not exist", name)
+	delete(m.cfg.Profiles, name)
+	if m.cfg.Profile == name {
+		m.cfg.Profile = ""
 	}
-
-	pm.current = name
-	return pm.saveCurrentProfile()
-}
-
-// GetCurrentProfile returns the current profile
-func (pm *Manager) GetCurrentProfile() *Profile {
-	pm.mu.RLock()
-	defer pm.mu.RUnlock()
-
-	return pm.profiles[pm.current]
+	return m.cfg.Save()
 }
 
-// GetCurrentProfileName returns the current profile name
-func (pm *Manager) GetCurrentProfileName() string {
-	pm.mu.RLock()
-	defer pm.mu.RUnlock()
-
-	return pm.current
+// Use makes name the profile commands connect with by default
+func (m *Manager) Use(name string) error {
+	if _, ok := m.cfg.Profiles[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	m.cfg.Profile = name
+	return m.cfg.Save()
 }
 
-// GetProfile returns a specific profile
-func (pm *Manager) GetProfile(name string) *Profile {
-	pm.mu.RLock()
-	defer pm.mu.RUnlock()
-
-	return pm.profiles[name]
+// Current returns the name of the current profile, or "" when commands
+// use the server_url and auth_token of the config
+func (m *Manager) Current() string {
+	return m.cfg.Profile
 }
 
-// ListProfiles returns all profiles
-func (pm *Manager) ListProfiles() map[string]*Profile {
-	pm.mu.RLock()
-	defer pm.mu.RUnlock()
-
-	// Return a copy
-	result := make(map[string]*Profile)
-	for name, profile := range pm.profiles {
-		result[name] = profile
+// Names returns the profile names in order
+func (m *Manager) Names() []string {
+	names := make([]string, 0, len(m.cfg.Profiles))
+	for name := range m.cfg.Profiles {
+		names = append(names, name)
 	}
-	return result
+	sort.Strings(names)
+	return names
 }
 
-// UpdateProfile updates a profile
-func (pm *Manager) UpdateProfile(name string, updates map[string]interface{}) error {
-	pm.mu.Lock()
-	defer pm.mu.Unlock()
+// Get returns a profile
+func (m *Manager) Get(name string) (*config.Profile, bool) {
+	profile, ok := m.cfg.Profiles[name]
+	return profile, ok
+}
 
-	profile, exists := pm.profiles[name]
-	if !exists {
-		return fmt.Errorf("profile '%s' does not exist", name)
+// TokenSource describes where the token of a profile comes from
+func (m *Manager) TokenSource(name string) (string, error) {
+	profile, ok := m.cfg.Profiles[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
 	}
-
-	// Update config fields
-	if configUpdates, ok := updates["config"].(map[string]interface{}); ok {
-		if serverURL, ok := configUpdates["server_url"].(string); ok {
-			profile.Config.ServerURL = serverURL
-		}
-		if authToken, ok := configUpdates["auth_token"].(string); ok {
-			profile.Config.AuthToken = authToken
-		}
-		if outputFormat, ok := configUpdates["output_format"].(string); ok {
-			profile.Config.OutputFormat = outputFormat
-		}
-		if theme, ok := configUpdates["theme"].(string); ok {
-			profile.Config.Theme = theme
-		}
-		if verbose, ok := configUpdates["verbose"].(bool); ok {
-			profile.Config.Verbose = verbose
-		}
+	if profile.TokenFile != "" {
+		return "file " + profile.TokenFile, nil
 	}
-
-	// Update metadata
-	if metadata, ok := updates["metadata"].(map[string]interface{}); ok {
-		for key, value := range metadata {
-			profile.Metadata[key] = value
-		}
+	_, err := m.store.Get(secretKey(name))
+	if errors.Is(err, secrets.ErrNotFound) {
+		return "none", nil
 	}
-
-	// Update description
-	if description, ok := updates["description"].(string); ok {
-		profile.Description = description
+	if err != nil {
+		return "", err
 	}
-
-	profile.UpdatedAt = getCurrentTimestamp()
-	return pm.saveProfile(profile)
+	return "stored in " + m.store.Name(), nil
 }
 
-// CloneProfile clones an existing profile
-func (pm *Manager) CloneProfile(sourceName, newName, description string) error {
-	pm.mu.Lock()
-	defer pm.mu.Unlock()
+// StoreName describes where tokens are kept
+func (m *Manager) StoreName() string {
+	return m.store.Name()
+}
 
-	sourceProfile, exists := pm.profiles[sourceName]
-	if !exists {
-		return fmt.Errorf("source profile '%s' does not exist", sourceName)
+// Resolve returns the connection of the named profile, or of the current
+// one when name is empty. It returns nil when no profile is selected.
+func (m *Manager) Resolve(name string) (*Connection, error) {
+	if name == "" {
+		name = m.cfg.Profile
 	}
-
-	if _, exists := pm.profiles[newName]; exists {
-		return fmt.Errorf("profile '%s' already exists", newName)
+	if name == "" {
+		return nil, nil
 	}
-
-	// Deep copy the config
-	newConfig := &config.Config{
-		ServerURL:    sourceProfile.Config.ServerURL,
-		AuthToken:    sourceProfile.Config.AuthToken,
-		OutputFormat: sourceProfile.Config.OutputFormat,
-		Theme:        sourceProfile.Config.Theme,
-		Verbose:      sourceProfile.Config.Verbose,
-		HistoryFile:  sourceProfile.Config.HistoryFile,
-	}
-
-	// Deep copy metadata
-	newMetadata := make(map[string]interface{})
-	for key, value := range sourceProfile.Metadata {
-		newMetadata[key] = value
+	profile, ok := m.cfg.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
 	}
 
-	newProfile := &Profile{
-		Name:        newName,
-		Description: description,
-		Config:      newConfig,
-		Metadata:    newMetadata,
-		CreatedAt:   getCurrentTimestamp(),
-		UpdatedAt:   getCurrentTimestamp(),
-	}
-
-	pm.profiles[newName] = newProfile
-	return pm.saveProfile(newProfile)
-}
-
-// ExportProfile exports a profile to a file
-func (pm *Manager) ExportProfile(name, filePath string) error {
-	pm.mu.RLock()
-	defer pm.mu.RUnlock()
-
-	profile, exists := pm.profiles[name]
-	if !exists {
-		return fmt.Errorf("profile '%s' does not exist", name)
+	conn := &Connection{Profile: name, ServerURL: profile.ServerURL, TLS: profile.TLS}
+	if profile.TokenFile != "" {
+		data, err := os.ReadFile(profile.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: failed to read token file: %w", name, err)
+		}
+		conn.Token = strings.TrimSpace(string(data))
+		return conn, nil
 	}
 
-	data, err := json.MarshalIndent(profile, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal profile: %w", err)
+	token, err := m.store.Get(secretKey(name))
+	if err != nil && !errors.Is(err, secrets.ErrNotFound) {
+		return nil, fmt.Errorf("profile %s: failed to read token: %w", name, err)
 	}
-
-	return os.WriteFile(filePath, data, 0644)
+	conn.Token = token
+	return conn, nil
 }
 
-// ImportProfile imports a profile from a file
-func (pm *Manager) ImportProfile(filePath string) error {
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to read profile file: %w", err)
-	}
-
-	var profile Profile
-	if err := json.Unmarshal(data, &profile); err != nil {
-		return fmt.Errorf("failed to unmarshal profile: %w", err)
+// validate checks the URL and that the TLS files load. File paths are
+// made absolute so the profile works from any directory.
+func validate(profile *config.Profile) error {
+	u, err := url.Parse(profile.ServerURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid server URL %q: expected http(s)://host[:port]", profile.ServerURL)
 	}
+	profile.ServerURL = strings.TrimSuffix(profile.ServerURL, "/")
 
-	pm.mu.Lock()
-	defer pm.mu.Unlock()
-
-	pm.profiles[profile.Name] = &profile
-	return pm.saveProfile(&profile)
-}
-
-// loadProfiles loads profiles from disk
-func (pm *Manager) loadProfiles() error {
-	profilesDir := filepath.Join(pm.configDir, "profiles")
-	if err := os.MkdirAll(profilesDir, 0755); err != nil {
-		return fmt.Errorf("failed to create profiles directory: %w", err)
+	paths := []*string{&profile.TokenFile}
+	if profile.TLS != nil {
+		paths = append(paths, &profile.TLS.CACert, &profile.TLS.ClientCert, &profile.TLS.ClientKey)
 	}
-
-	// Load current profile setting
-	currentFile := filepath.Join(profilesDir, "current.json")
-	if data, err := os.ReadFile(currentFile); err == nil {
-		var current struct {
-			Current string `json:"current"`
+	for _, path := range paths {
+		if *path == "" {
+			continue
 		}
-		if err := json.Unmarshal(data, &current); err == nil {
-			pm.current = current.Current
+		if *path, err = filepath.Abs(*path); err != nil {
+			return err
 		}
 	}
 
-	// Load individual profiles
-	files, err := os.ReadDir(profilesDir)
-	if err != nil {
-		return fmt.Errorf("failed to read profiles directory: %w", err)
-	}
-
-	for _, file := range files {
-		if file.IsDir() || file.Name() == "current.json" {
-			continue
+	if profile.TokenFile != "" {
+		if _, err := os.Stat(profile.TokenFile); err != nil {
+			return fmt.Errorf("token file: %w", err)
 		}
-
-		profilePath := filepath.Join(profilesDir, file.Name())
-		data, err := os.ReadFile(profilePath)
-		if err != nil {
-			continue // Skip files that can't be read
+	}
+	if profile.TLS != nil {
+		if u.Scheme != "https" {
+			return fmt.Errorf("TLS options need an https:// URL")
 		}
-
-		var profile Profile
-		if err := json.Unmarshal(data, &profile); err != nil {
-			continue // Skip files that can't be parsed
+		if _, err := client.NewTLSConfig(profile.TLS); err != nil {
+			return err
 		}
-
-		pm.profiles[profile.Name] = &profile
 	}
-
 	return nil
 }
 
-// saveProfile saves a profile to disk
-func (pm *Manager) saveProfile(profile *Profile) error {
-	profilesDir := filepath.Join(pm.configDir, "profiles")
-	if err := os.MkdirAll(profilesDir, 0755); err != nil {
-		return fmt.Errorf("failed to create profiles directory: %w", err)
-	}
-
-	profileFile := filepath.Join(profilesDir, profile.Name+".json")
-	data, err := json.MarshalIndent(profile, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal profile: %w", err)
-	}
-
-	return os.WriteFile(profileFile, data, 0644)
-}
-
-// saveCurrentProfile saves the current profile setting
-func (pm *Manager) saveCurrentProfile() error {
-	profilesDir := filepath.Join(pm.configDir, "profiles")
-	if err := os.MkdirAll(profilesDir, 0755); err != nil {
-		return fmt.Errorf("failed to create profiles directory: %w", err)
-	}
-
-	currentFile := filepath.Join(profilesDir, "current.json")
-	data, err := json.MarshalIndent(map[string]string{"current": pm.current}, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal current profile: %w", err)
-	}
-
-	return os.WriteFile(currentFile, data, 0644)
-}
-
-// deleteProfileFile deletes a profile file from disk
-func (pm *Manager) deleteProfileFile(name string) error {
-	profilesDir := filepath.Join(pm.configDir, "profiles")
-	profileFile := filepath.Join(profilesDir, name+".json")
-	return os.Remove(profileFile)
-}
-
-// getCurrentTimestamp returns the current timestamp
-func getCurrentTimestamp() string {
-	return "2024-01-01T00:00:00Z" // In a real implementation, use time.Now().Format(time.RFC3339)
+func secretKey(profile string) string {
+	return "profile/" + profile
 }
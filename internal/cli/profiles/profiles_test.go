@@ -0,0 +1,105 @@
+package profiles
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Skpow1234/Peervault/internal/cli/config"
+	"github.com/Skpow1234/Peervault/internal/cli/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newManager returns a manager whose config is saved under a temporary home
+func newManager(t *testing.T) (*Manager, secrets.Store) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	store := secrets.NewFileStore(filepath.Join(home, "credentials.json"))
+	return New(config.Default(), store), store
+}
+
+func TestAddUseRemove(t *testing.T) {
+	m, store := newManager(t)
+
+	conn, err := m.Resolve("")
+	require.NoError(t, err)
+	assert.Nil(t, conn, "no profile until one is added")
+
+	require.NoError(t, m.Add("prod", &config.Profile{ServerURL: "https://vault.example.com/"}, "prod-token"))
+	require.NoError(t, m.Add("dev", &config.Profile{ServerURL: "http://localhost:8081"}, ""))
+	assert.Equal(t, "prod", m.Current(), "the first profile becomes current")
+	assert.Equal(t, []string{"dev", "prod"}, m.Names())
+
+	conn, err = m.Resolve("")
+	require.NoError(t, err)
+	assert.Equal(t, &Connection{Profile: "prod", ServerURL: "https://vault.example.com", Token: "prod-token"}, conn)
+
+	// The token is kept out of the saved config
+	loaded, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "prod", loaded.Profile)
+	assert.Equal(t, &config.Profile{ServerURL: "https://vault.example.com"}, loaded.Profiles["prod"])
+
+	source, err := m.TokenSource("dev")
+	require.NoError(t, err)
+	assert.Equal(t, "none", source)
+
+	require.NoError(t, m.Use("dev"))
+	conn, err = m.Resolve("")
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8081", conn.ServerURL)
+
+	require.NoError(t, m.Remove("prod"))
+	_, err = store.Get("profile/prod")
+	assert.ErrorIs(t, err, secrets.ErrNotFound)
+	require.NoError(t, m.Remove("dev"))
+	assert.Equal(t, "", m.Current())
+
+	_, err = m.Resolve("prod")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, m.Use("prod"), ErrNotFound)
+}
+
+func TestTokenFile(t *testing.T) {
+	m, _ := newManager(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("file-token\n"), 0600))
+
+	require.NoError(t, m.Add("ci", &config.Profile{ServerURL: "http://ci:8081", TokenFile: tokenFile}, ""))
+	conn, err := m.Resolve("ci")
+	require.NoError(t, err)
+	assert.Equal(t, "file-token", conn.Token)
+
+	// The file is read on every use, so rotating it needs no profile change
+	require.NoError(t, os.WriteFile(tokenFile, []byte("rotated"), 0600))
+	conn, err = m.Resolve("ci")
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", conn.Token)
+
+	err = m.Add("both", &config.Profile{ServerURL: "http://ci:8081", TokenFile: tokenFile}, "token")
+	assert.Error(t, err)
+}
+
+func TestAddValidates(t *testing.T) {
+	m, _ := newManager(t)
+
+	tests := []struct {
+		name    string
+		profile *config.Profile
+	}{
+		{"bad name", &config.Profile{ServerURL: "http://localhost:8081"}},
+		{"noscheme", &config.Profile{ServerURL: "localhost:8081"}},
+		{"ftp", &config.Profile{ServerURL: "ftp://localhost"}},
+		{"missingfile", &config.Profile{ServerURL: "http://localhost:8081", TokenFile: "/nonexistent/token"}},
+		{"tlsoverhttp", &config.Profile{ServerURL: "http://localhost:8081", TLS: &config.TLSConfig{InsecureSkipVerify: true}}},
+		{"missingca", &config.Profile{ServerURL: "https://localhost", TLS: &config.TLSConfig{CACert: "/nonexistent/ca.pem"}}},
+		{"halfcert", &config.Profile{ServerURL: "https://localhost", TLS: &config.TLSConfig{ClientCert: "/nonexistent/cert.pem"}}},
+	}
+	for _, tt := range tests {
+		assert.Error(t, m.Add(tt.name, tt.profile, ""), tt.name)
+	}
+	assert.Empty(t, m.Names())
+}
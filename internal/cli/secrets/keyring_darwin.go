@@ -0,0 +1,79 @@
+//go:build darwin
+
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// itemNotFound is the exit status of security(1) for a missing item
+const itemNotFound = 44
+
+// keychain stores secrets as generic passwords in the login keychain
+type keychain struct{}
+
+func newKeyring() (Store, bool) {
+	if _, err := exec.LookPath("security"); err != nil {
+		return nil, false
+	}
+	return keychain{}, true
+}
+
+func (keychain) Get(key string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("security", "find-generic-password", "-s", Service, "-a", key, "-w")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", keychainError("find-generic-password", err, &stderr)
+	}
+	return strings.TrimSuffix(stdout.String(), "\n"), nil
+}
+
+func (keychain) Set(key, secret string) error {
+	// Pass the command on stdin so the secret never shows in the process list
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+		quote(Service), quote(key), quote(secret)))
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return keychainError("add-generic-password", err, &stderr)
+	}
+	return nil
+}
+
+func (keychain) Delete(key string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "delete-generic-password", "-s", Service, "-a", key)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if err := keychainError("delete-generic-password", err, &stderr); !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (keychain) Name() string {
+	return "the macOS Keychain"
+}
+
+func keychainError(op string, err error, stderr *bytes.Buffer) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == itemNotFound {
+		return ErrNotFound
+	}
+	if stderr.Len() > 0 {
+		return fmt.Errorf("security %s: %s", op, strings.TrimSpace(stderr.String()))
+	}
+	return fmt.Errorf("security %s: %w", op, err)
+}
+
+// quote quotes s for the security(1) interactive command parser
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
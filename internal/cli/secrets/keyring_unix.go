@@ -0,0 +1,73 @@
+//go:build !windows && !darwin
+
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// secretTool stores secrets with the Secret Service (GNOME Keyring, KWallet)
+// through libsecret's secret-tool
+type secretTool struct {
+	path string
+}
+
+func newKeyring() (Store, bool) {
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		return nil, false
+	}
+	path, err := exec.LookPath("secret-tool")
+	if err != nil {
+		return nil, false
+	}
+	return &secretTool{path: path}, true
+}
+
+func (s *secretTool) Get(key string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(s.path, "lookup", "service", Service, "account", key)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	// lookup exits 1 without output when nothing matches
+	if err != nil && stdout.Len() == 0 && stderr.Len() == 0 {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("secret-tool lookup: %s", strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSuffix(stdout.String(), "\n"), nil
+}
+
+func (s *secretTool) Set(key, secret string) error {
+	cmd := exec.Command(s.path, "store", "--label", Service+" "+key, "service", Service, "account", key)
+	cmd.Stdin = strings.NewReader(secret)
+	return run(cmd, "secret-tool store")
+}
+
+func (s *secretTool) Delete(key string) error {
+	cmd := exec.Command(s.path, "clear", "service", Service, "account", key)
+	return run(cmd, "secret-tool clear")
+}
+
+func (s *secretTool) Name() string {
+	return "the Secret Service keyring"
+}
+
+// run runs cmd, folding its stderr into the error
+func run(cmd *exec.Cmd, name string) error {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && stderr.Len() > 0 {
+			return fmt.Errorf("%s: %s", name, strings.TrimSpace(stderr.String()))
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// Package secrets stores CLI credentials such as API tokens in the OS
+// keyring: the macOS Keychain, the Windows Credential Manager or, on Linux
+// and other Unix systems, the Secret Service through secret-tool. Where no
+// keyring is available they fall back to a file only the user can read.
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Service is the keyring service secrets are stored under
+const Service = "peervault-cli"
+
+// ErrNotFound is returned when no secret is stored under a key
+var ErrNotFound = errors.New("secret not found")
+
+// Store keeps secrets by key
+type Store interface {
+	// Get returns the secret stored under key, or ErrNotFound
+	Get(key string) (string, error)
+	// Set stores secret under key, replacing any previous one
+	Set(key, secret string) error
+	// Delete removes the secret under key; deleting a missing key is not an error
+	Delete(key string) error
+	// Name describes where secrets are kept, for messages
+	Name() string
+}
+
+// Open returns the OS keyring when one is available, otherwise a file store
+// in dir
+func Open(dir string) Store {
+	if store, ok := newKeyring(); ok {
+		return store
+	}
+	return NewFileStore(filepath.Join(dir, "credentials.json"))
+}
+
+// FileStore keeps secrets in a JSON file readable only by its owner
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileStore creates a store backed by the file at path
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Get returns the secret stored under key
+func (s *FileStore) Get(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secrets, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	secret, ok := secrets[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+// Set stores secret under key
+func (s *FileStore) Set(key, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	secrets[key] = secret
+	return s.save(secrets)
+}
+
+// Delete removes the secret under key
+func (s *FileStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[key]; !ok {
+		return nil
+	}
+	delete(secrets, key)
+	return s.save(secrets)
+}
+
+// Name describes the store
+func (s *FileStore) Name() string {
+	return s.path
+}
+
+func (s *FileStore) load() (map[string]string, error) {
+	secrets := make(map[string]string)
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", s.path, err)
+	}
+	return secrets, nil
+}
+
+func (s *FileStore) save(secrets map[string]string) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create credentials directory: %w", err)
+	}
+	data, err := json.MarshalIndent(secrets, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode credentials: %w", err)
+	}
+
+	// Write then rename so a failed write never truncates the old file
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peervault", "credentials.json")
+	store := NewFileStore(path)
+
+	_, err := store.Get("profile/prod")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, store.Set("profile/prod", "s3cret"))
+	require.NoError(t, store.Set("profile/dev", "dev-token"))
+	require.NoError(t, store.Set("profile/prod", "rotated"))
+
+	secret, err := store.Get("profile/prod")
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", secret)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	require.NoError(t, store.Delete("profile/prod"))
+	require.NoError(t, store.Delete("profile/prod"), "deleting a missing key is not an error")
+	_, err = store.Get("profile/prod")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// A new store reads what the first one wrote
+	secret, err = NewFileStore(path).Get("profile/dev")
+	require.NoError(t, err)
+	assert.Equal(t, "dev-token", secret)
+}
@@ -157,10 +157,15 @@ const (
 	ColorDefault
 )
 
-// IsTerminal checks if stdout is a terminal (simplified implementation)
+// IsTerminal checks if stdout is a terminal
 func IsTerminal() bool {
-	// Simplified check - in a real implementation, you'd use platform-specific code
-	return true
+	return IsTerminalFile(os.Stdout)
+}
+
+// IsTerminalFile checks if f is a terminal rather than a pipe or file
+func IsTerminalFile(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
 
 // GetTerminalType returns the terminal type
@@ -0,0 +1,307 @@
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/pkg/e2e"
+	"github.com/Skpow1234/Peervault/pkg/merkle"
+)
+
+// downloadState records the chunks of an interrupted download already in
+// the partial file
+type downloadState struct {
+	Key       string `json:"key"`
+	Hash      string `json:"hash"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunk_size"`
+	Done      []int  `json:"done"`
+}
+
+// Download saves the file stored under key to outputPath. Files larger
+// than one chunk are fetched as parallel byte ranges into outputPath.part,
+// which is renamed into place once the content has been verified against
+// the file's hash. If an earlier run was interrupted, only the missing
+// chunks are fetched, provided the file has not changed since. When the
+// server serves the Merkle tree of the file, each chunk is checked against
+// it as it arrives, and the chunks outputPath already holds from an earlier
+// copy of the file are reused instead of fetched. Files encrypted on the
+// client are decrypted with the key DecryptionKey returns for them, which
+// is checked against the file's metadata first.
+func (e *Engine) Download(ctx context.Context, key, outputPath string) (*client.FileInfo, error) {
+	info, err := e.cluster.GetFile(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+	decryptionKey, err := e.decryptionKey(info)
+	if err != nil {
+		return nil, err
+	}
+
+	partPath := outputPath + ".part"
+	statePath := partPath + ".json"
+	if info.Size <= e.opts.ChunkSize {
+		err = e.downloadWhole(ctx, info, partPath)
+	} else {
+		earlier := outputPath
+		if decryptionKey != nil {
+			// The earlier copy is plaintext, so it shares no chunks
+			earlier = ""
+		}
+		err = e.downloadChunks(ctx, info, partPath, statePath, earlier)
+		if errors.Is(err, client.ErrRangeIgnored) {
+			// The server cannot serve ranges, or the file changed under us
+			_ = os.Remove(statePath)
+			err = e.downloadWhole(ctx, info, partPath)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verify(partPath, info.Hash); err != nil {
+		_ = os.Remove(partPath)
+		_ = os.Remove(statePath)
+		return nil, err
+	}
+	if decryptionKey != nil {
+		err = decryptFile(decryptionKey, partPath, outputPath)
+		_ = os.Remove(partPath)
+	} else {
+		err = os.Rename(partPath, outputPath)
+	}
+	_ = os.Remove(statePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save file: %w", err)
+	}
+	return info, nil
+}
+
+// decryptionKey returns the key decrypting a file encrypted on the client,
+// and nil for files stored in plaintext
+func (e *Engine) decryptionKey(info *client.FileInfo) (*e2e.Key, error) {
+	scheme, keyID, ok := e2e.Encrypted(info.Metadata)
+	if !ok {
+		return nil, nil
+	}
+	if e.opts.DecryptionKey == nil {
+		return nil, fmt.Errorf("%s is encrypted on the client (%s) and needs a key to download", info.Key, scheme)
+	}
+	key, err := e.opts.DecryptionKey(scheme, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := key.Check(info.Metadata); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// decryptFile writes the plaintext of the encrypted file at src to dst,
+// which is only replaced once the whole file decrypted
+func decryptFile(key *e2e.Key, src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	tmp := dst + ".decrypt"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = key.Decrypt(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// downloadWhole fetches the file in a single request
+func (e *Engine) downloadWhole(ctx context.Context, info *client.FileInfo, partPath string) error {
+	t := newTracker(e.opts.OnProgress, info.Size, 0)
+	return retry(ctx, e.opts.Retries, func() error {
+		out, err := os.Create(partPath)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		w := &progressWriter{w: out, tracker: t}
+		_, err = e.cluster.DownloadContent(ctx, info.Key, w)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			w.undo()
+		}
+		return err
+	})
+}
+
+// manifest returns the Merkle tree of the file at the chunk size of the
+// transfer, or nil when the server does not serve it
+func (e *Engine) manifest(ctx context.Context, info *client.FileInfo) (*merkle.Manifest, error) {
+	m, err := e.cluster.GetFileManifest(ctx, info.Key, e.opts.ChunkSize)
+	if err != nil {
+		return nil, nil
+	}
+	if err := m.Verify(); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if m.Size != info.Size || m.ChunkSize != e.opts.ChunkSize ||
+		(m.ChunkSize == merkle.DefaultChunkSize && info.MerkleRoot != "" && m.Root != info.MerkleRoot) {
+		return nil, fmt.Errorf("invalid manifest: %w", merkle.ErrMismatch)
+	}
+	return m, nil
+}
+
+// reuseChunks copies the chunks the file at earlier has in common with m
+// into out, returning their indexes
+func reuseChunks(earlier string, out io.WriterAt, m *merkle.Manifest) []int {
+	f, err := os.Open(earlier)
+	if err != nil {
+		return nil
+	}
+	defer func() { _ = f.Close() }()
+
+	var reused []int
+	buf := make([]byte, m.ChunkSize)
+	for i := range m.Chunks {
+		offset, length := m.Chunk(i)
+		n, err := f.ReadAt(buf[:length], offset)
+		if int64(n) != length {
+			if err != nil {
+				break
+			}
+			continue
+		}
+		if m.VerifyChunk(i, buf[:length]) != nil {
+			continue
+		}
+		if _, err := out.WriteAt(buf[:length], offset); err != nil {
+			break
+		}
+		reused = append(reused, i)
+	}
+	return reused
+}
+
+// downloadChunks fetches the missing chunks into partPath, recording each
+// finished chunk in the state file. A fresh download first takes the
+// chunks it can from the earlier copy of the file at earlier.
+func (e *Engine) downloadChunks(ctx context.Context, info *client.FileInfo, partPath, statePath, earlier string) error {
+	manifest, err := e.manifest(ctx, info)
+	if err != nil {
+		return err
+	}
+
+	state := downloadState{Key: info.Key, Hash: info.Hash, Size: info.Size, ChunkSize: e.opts.ChunkSize}
+	var previous downloadState
+	flags := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if loadState(statePath, &previous) && previous.Key == state.Key && previous.Hash == state.Hash &&
+		previous.Size == state.Size && previous.ChunkSize == state.ChunkSize {
+		if _, err := os.Stat(partPath); err == nil {
+			state.Done = previous.Done
+			flags = os.O_RDWR
+		}
+	}
+
+	out, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer func() { _ = out.Close() }()
+	if err := out.Truncate(info.Size); err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	if manifest != nil && earlier != "" && flags&os.O_TRUNC != 0 {
+		state.Done = reuseChunks(earlier, out, manifest)
+	}
+	if err := saveState(statePath, &state); err != nil {
+		return fmt.Errorf("failed to save download state: %w", err)
+	}
+
+	done := make(map[int]bool, len(state.Done))
+	var resumed int64
+	for _, index := range state.Done {
+		done[index] = true
+		resumed += min(e.opts.ChunkSize, info.Size-int64(index)*e.opts.ChunkSize)
+	}
+	t := newTracker(e.opts.OnProgress, info.Size, resumed)
+
+	var mu sync.Mutex
+	err = e.run(ctx, chunks(info.Size, e.opts.ChunkSize, done), func(ctx context.Context, c chunk) error {
+		sum := sha256.New()
+		w := &progressWriter{w: io.MultiWriter(io.NewOffsetWriter(out, c.offset), sum), tracker: t}
+		n, err := e.cluster.DownloadRange(ctx, info.Key, info.Hash, c.offset, c.length, w)
+		if err == nil && n != c.length {
+			err = fmt.Errorf("short read: got %d of %d bytes", n, c.length)
+		}
+		if err == nil && manifest != nil {
+			// A corrupt chunk is fetched again rather than the whole file
+			err = manifest.VerifyChunkSum(c.index, sum.Sum(nil))
+		}
+		if err != nil {
+			w.undo()
+			if errors.Is(err, client.ErrRangeIgnored) {
+				return &permanentError{err}
+			}
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		state.Done = append(state.Done, c.index)
+		sort.Ints(state.Done)
+		return saveState(statePath, &state)
+	})
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return permanent.err
+	}
+	if err != nil {
+		return fmt.Errorf("download interrupted, run the command again to resume: %w", err)
+	}
+	return out.Close()
+}
+
+// verify checks the downloaded content against the SHA-256 the server
+// recorded for the file
+func verify(path, hash string) error {
+	if len(hash) != sha256.Size*2 {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("failed to verify download: %w", err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != hash {
+		return fmt.Errorf("downloaded content has hash %s, expected %s", sum, hash)
+	}
+	return nil
+}
+
+// permanentError is a chunk failure that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string { return p.err.Error() }
+func (p *permanentError) Unwrap() error { return p.err }
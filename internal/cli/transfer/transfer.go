@@ -0,0 +1,274 @@
+// Package transfer moves single large files to and from a PeerVault
+// server. Files bigger than one chunk are split into chunks that parallel
+// workers upload as parts of a resumable upload or download as byte
+// ranges. The state of an unfinished transfer is kept on disk, so running
+// the same command again only transfers the chunks still missing.
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/pkg/e2e"
+	"github.com/Skpow1234/Peervault/pkg/merkle"
+)
+
+const (
+	// DefaultWorkers is the number of chunks transferred in parallel
+	DefaultWorkers = 4
+	// DefaultChunkSize is the size of a chunk; smaller files are sent in a
+	// single request
+	DefaultChunkSize = 8 << 20
+	// MaxWorkers bounds --parallel
+	MaxWorkers = 32
+)
+
+// Cluster is the part of the API client used by transfers
+type Cluster interface {
+	ServerURL() string
+	UploadData(ctx context.Context, name, contentType string, r io.Reader, tags []string, metadata map[string]string) (*client.FileInfo, error)
+	CreateUpload(ctx context.Context, opts *client.UploadOptions) (*client.Upload, error)
+	GetUpload(ctx context.Context, uploadID string) (*client.Upload, error)
+	UploadPart(ctx context.Context, uploadID string, part int, r io.Reader, size int64, checksum string) error
+	CompleteUpload(ctx context.Context, uploadID string) (*client.FileInfo, error)
+	GetFile(ctx context.Context, key string) (*client.FileInfo, error)
+	DownloadContent(ctx context.Context, key string, w io.Writer) (int64, error)
+	DownloadRange(ctx context.Context, key, hash string, offset, length int64, w io.Writer) (int64, error)
+	GetFileManifest(ctx context.Context, key string, chunkSize int64) (*merkle.Manifest, error)
+}
+
+// Options tunes a transfer
+type Options struct {
+	// Workers is the number of chunks transferred in parallel
+	Workers int
+	// ChunkSize is the size of a chunk; defaults to DefaultChunkSize
+	ChunkSize int64
+	// Retries is how many more times a failed chunk is attempted
+	Retries int
+	// StateDir keeps the state of unfinished uploads; empty disables
+	// resuming them. Downloads keep their state next to the output file.
+	StateDir string
+	// OnProgress is called as bytes are transferred; calls are serialised
+	OnProgress func(Progress)
+	// Encryption encrypts uploads before they leave the client, with a key
+	// or passphrase the server never sees
+	Encryption *e2e.Key
+	// DecryptionKey is asked for the key of a download that was encrypted
+	// on the client, given the scheme and key ID in its metadata
+	DecryptionKey func(scheme, keyID string) (*e2e.Key, error)
+}
+
+// Progress counts the bytes transferred so far
+type Progress struct {
+	Total int64 `json:"total"`
+	Done  int64 `json:"done"`
+	// Resumed is the part of Done transferred by an earlier run, or taken
+	// from the earlier copy of the file
+	Resumed int64 `json:"resumed"`
+}
+
+// Engine uploads and downloads files in parallel chunks
+type Engine struct {
+	cluster Cluster
+	opts    Options
+}
+
+// New creates an engine transferring files to and from cluster
+func New(cluster Cluster, opts Options) *Engine {
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+	if opts.Workers > MaxWorkers {
+		opts.Workers = MaxWorkers
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+	return &Engine{cluster: cluster, opts: opts}
+}
+
+// chunk is a byte range of a file
+type chunk struct {
+	index  int
+	offset int64
+	length int64
+}
+
+// chunks splits size bytes into chunks of chunkSize, leaving out the ones
+// in done
+func chunks(size, chunkSize int64, done map[int]bool) []chunk {
+	var list []chunk
+	for i, offset := 0, int64(0); offset < size; i, offset = i+1, offset+chunkSize {
+		if done[i] {
+			continue
+		}
+		list = append(list, chunk{index: i, offset: offset, length: min(chunkSize, size-offset)})
+	}
+	return list
+}
+
+// run transfers chunks with the configured number of workers. The first
+// chunk to fail for good stops the others; the chunks that completed stay
+// completed, so the transfer can resume.
+func (e *Engine) run(ctx context.Context, list []chunk, fn func(ctx context.Context, c chunk) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	work := make(chan chunk)
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for i := 0; i < min(e.opts.Workers, len(list)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range work {
+				err := retry(ctx, e.opts.Retries, func() error { return fn(ctx, c) })
+				if err != nil {
+					once.Do(func() {
+						firstErr = fmt.Errorf("chunk %d: %w", c.index, err)
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, c := range list {
+		select {
+		case work <- c:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func retry(ctx context.Context, retries int, fn func() error) error {
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * 500 * time.Millisecond):
+			}
+		}
+		if err = fn(); err == nil || ctx.Err() != nil {
+			return err
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return err
+		}
+	}
+	return err
+}
+
+// tracker accumulates progress from concurrent workers
+type tracker struct {
+	mu         sync.Mutex
+	progress   Progress
+	onProgress func(Progress)
+}
+
+func newTracker(onProgress func(Progress), total, resumed int64) *tracker {
+	t := &tracker{onProgress: onProgress, progress: Progress{Total: total, Done: resumed, Resumed: resumed}}
+	t.add(0)
+	return t
+}
+
+// add records n more bytes; n is negative when a failed chunk is retried
+func (t *tracker) add(n int64) {
+	if t.onProgress == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.progress.Done += n
+	t.onProgress(t.progress)
+}
+
+// progressReader reports the bytes read through it. The HTTP transport
+// may still be reading a request body after a failed request returns,
+// hence the atomic count.
+type progressReader struct {
+	r       io.Reader
+	tracker *tracker
+	n       atomic.Int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.n.Add(int64(n))
+	p.tracker.add(int64(n))
+	return n, err
+}
+
+// undo takes the bytes of a failed attempt back out of the progress
+func (p *progressReader) undo() {
+	p.tracker.add(-p.n.Swap(0))
+}
+
+// progressWriter reports the bytes written through it
+type progressWriter struct {
+	w       io.Writer
+	tracker *tracker
+	n       int64
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.n += int64(n)
+	p.tracker.add(int64(n))
+	return n, err
+}
+
+func (p *progressWriter) undo() {
+	p.tracker.add(-p.n)
+	p.n = 0
+}
+
+// loadState reads the JSON state at path into v, reporting whether there
+// was one
+func loadState(path string, v interface{}) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
+
+// saveState writes v to path, replacing it atomically
+func saveState(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,270 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Skpow1234/Peervault/internal/api/rest/endpoints"
+	"github.com/Skpow1234/Peervault/internal/api/rest/implementations"
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/cli/config"
+	"github.com/Skpow1234/Peervault/internal/uploads"
+	"github.com/Skpow1234/Peervault/pkg/e2e"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testChunk = uploads.MinPartSize
+
+// newTestServer serves the file and upload endpoints of the REST API
+func newTestServer(t *testing.T) *client.Client {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	manager, err := uploads.NewManager(uploads.ManagerOpts{Dir: t.TempDir()})
+	require.NoError(t, err)
+	files := implementations.NewFileService()
+	fileEndpoints := endpoints.NewFileEndpoints(files, logger)
+	uploadEndpoints := endpoints.NewUploadEndpoints(implementations.NewUploadService(manager, files), logger)
+
+	api := http.NewServeMux()
+	api.HandleFunc("POST /files", fileEndpoints.HandleUploadFile)
+	api.HandleFunc("GET /files/get", fileEndpoints.HandleGetFile)
+	api.HandleFunc("GET /files/{key}/content", fileEndpoints.HandleDownloadFile)
+	api.HandleFunc("GET /files/{key}/manifest", fileEndpoints.HandleGetFileManifest)
+	api.HandleFunc("POST /uploads", uploadEndpoints.HandleCreateUpload)
+	api.HandleFunc("GET /uploads/{id}", uploadEndpoints.HandleGetUpload)
+	api.HandleFunc("PUT /uploads/{id}/parts/{part}", uploadEndpoints.HandleUploadPart)
+	api.HandleFunc("POST /uploads/{id}/complete", uploadEndpoints.HandleCompleteUpload)
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/", http.StripPrefix("/api/v1", api))
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return client.New(&config.Config{ServerURL: server.URL})
+}
+
+func writeRandomFile(t *testing.T, size int) (string, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "data.bin")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	return path, data
+}
+
+// flakyCluster fails the chunks in fail, corrupts the first download of
+// the chunks in corrupt and counts the chunks sent
+type flakyCluster struct {
+	*client.Client
+	fail    map[int]bool
+	corrupt map[int]bool
+	parts   atomic.Int32
+}
+
+var errInjected = errors.New("injected failure")
+
+func (f *flakyCluster) UploadPart(ctx context.Context, uploadID string, part int, r io.Reader, size int64, checksum string) error {
+	f.parts.Add(1)
+	if f.fail[part] {
+		return errInjected
+	}
+	return f.Client.UploadPart(ctx, uploadID, part, r, size, checksum)
+}
+
+func (f *flakyCluster) DownloadRange(ctx context.Context, key, hash string, offset, length int64, w io.Writer) (int64, error) {
+	f.parts.Add(1)
+	index := int(offset / testChunk)
+	if f.fail[index] {
+		return 0, errInjected
+	}
+	if f.corrupt[index] {
+		delete(f.corrupt, index)
+		var buf bytes.Buffer
+		n, err := f.Client.DownloadRange(ctx, key, hash, offset, length, &buf)
+		buf.Bytes()[0] ^= 1
+		_, _ = w.Write(buf.Bytes())
+		return n, err
+	}
+	return f.Client.DownloadRange(ctx, key, hash, offset, length, w)
+}
+
+func TestUploadDownloadInParallel(t *testing.T) {
+	c := newTestServer(t)
+	path, data := writeRandomFile(t, 5*testChunk+1234)
+
+	var last Progress
+	opts := Options{Workers: 3, ChunkSize: testChunk, StateDir: t.TempDir(), OnProgress: func(p Progress) { last = p }}
+	info, err := New(c, opts).Upload(context.Background(), path, []string{"big"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size)
+	assert.Equal(t, []string{"big"}, info.Tags)
+	assert.Equal(t, Progress{Total: int64(len(data)), Done: int64(len(data))}, last)
+
+	out := filepath.Join(t.TempDir(), "out.bin")
+	_, err = New(c, opts).Download(context.Background(), info.Key, out)
+	require.NoError(t, err)
+	got, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, got))
+	assert.NoFileExists(t, out+".part")
+	assert.NoFileExists(t, out+".part.json")
+
+	// Small files go in a single request
+	small, smallData := writeRandomFile(t, 100)
+	info, err = New(c, opts).Upload(context.Background(), small, nil, nil)
+	require.NoError(t, err)
+	_, err = New(c, opts).Download(context.Background(), info.Key, out)
+	require.NoError(t, err)
+	got, err = os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, smallData, got)
+}
+
+func TestUploadResumes(t *testing.T) {
+	c := newTestServer(t)
+	path, data := writeRandomFile(t, 6*testChunk)
+	opts := Options{Workers: 1, ChunkSize: testChunk, StateDir: t.TempDir()}
+
+	flaky := &flakyCluster{Client: c, fail: map[int]bool{3: true}}
+	_, err := New(flaky, opts).Upload(context.Background(), path, nil, nil)
+	require.ErrorIs(t, err, errInjected)
+	assert.Contains(t, err.Error(), "run the command again to resume")
+
+	// The rerun only sends the parts that did not make it
+	flaky = &flakyCluster{Client: c}
+	var last Progress
+	opts.OnProgress = func(p Progress) { last = p }
+	info, err := New(flaky, opts).Upload(context.Background(), path, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size)
+	assert.Equal(t, int32(3), flaky.parts.Load())
+	assert.Equal(t, int64(3*testChunk), last.Resumed)
+
+	entries, err := os.ReadDir(opts.StateDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "state is removed once the upload completes")
+}
+
+func TestDownloadResumes(t *testing.T) {
+	c := newTestServer(t)
+	path, data := writeRandomFile(t, 4*testChunk+10)
+	opts := Options{Workers: 1, ChunkSize: testChunk}
+	info, err := New(c, opts).Upload(context.Background(), path, nil, nil)
+	require.NoError(t, err)
+
+	out := filepath.Join(t.TempDir(), "out.bin")
+	flaky := &flakyCluster{Client: c, fail: map[int]bool{2: true}}
+	_, err = New(flaky, opts).Download(context.Background(), info.Key, out)
+	require.ErrorIs(t, err, errInjected)
+	assert.NoFileExists(t, out)
+	assert.FileExists(t, out+".part.json")
+
+	flaky = &flakyCluster{Client: c}
+	_, err = New(flaky, opts).Download(context.Background(), info.Key, out)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), flaky.parts.Load())
+	got, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, got))
+}
+
+func TestDownloadVerifiesChunksAndReusesEarlierCopy(t *testing.T) {
+	c := newTestServer(t)
+	path, data := writeRandomFile(t, 5*testChunk+10)
+	opts := Options{Workers: 1, ChunkSize: testChunk, Retries: 1}
+	info, err := New(c, opts).Upload(context.Background(), path, nil, nil)
+	require.NoError(t, err)
+	assert.NotEmpty(t, info.MerkleRoot)
+
+	// A chunk corrupted in transit is caught by the manifest and fetched again
+	out := filepath.Join(t.TempDir(), "out.bin")
+	flaky := &flakyCluster{Client: c, corrupt: map[int]bool{1: true}}
+	_, err = New(flaky, opts).Download(context.Background(), info.Key, out)
+	require.NoError(t, err)
+	assert.Equal(t, int32(7), flaky.parts.Load())
+
+	// A new version changing one chunk and growing the last only fetches
+	// those two; the rest comes from the earlier copy
+	data[2*testChunk+7] ^= 1
+	data = append(data, []byte("more")...)
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	info, err = New(c, opts).Upload(context.Background(), path, nil, nil)
+	require.NoError(t, err)
+
+	var last Progress
+	opts.OnProgress = func(p Progress) { last = p }
+	flaky = &flakyCluster{Client: c}
+	_, err = New(flaky, opts).Download(context.Background(), info.Key, out)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), flaky.parts.Load())
+	assert.Equal(t, int64(4*testChunk), last.Resumed)
+	got, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, got))
+}
+
+func TestEncryptedUploadResumesAndDownloads(t *testing.T) {
+	c := newTestServer(t)
+	path, data := writeRandomFile(t, 3*testChunk)
+	passphrase, err := e2e.NewPassphrase("s3cret")
+	require.NoError(t, err)
+	opts := Options{Workers: 1, ChunkSize: testChunk, StateDir: t.TempDir(), Encryption: passphrase}
+
+	flaky := &flakyCluster{Client: c, fail: map[int]bool{2: true}}
+	_, err = New(flaky, opts).Upload(context.Background(), path, nil, map[string]string{"team": "infra"})
+	require.ErrorIs(t, err, errInjected)
+
+	// Resuming with another passphrase would mix two keys in one file
+	other, err := e2e.NewPassphrase("typo")
+	require.NoError(t, err)
+	wrongOpts := opts
+	wrongOpts.Encryption = other
+	_, err = New(c, wrongOpts).Upload(context.Background(), path, nil, map[string]string{"team": "infra"})
+	require.ErrorIs(t, err, e2e.ErrWrongKey)
+
+	flaky = &flakyCluster{Client: c}
+	info, err := New(flaky, opts).Upload(context.Background(), path, nil, map[string]string{"team": "infra"})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), flaky.parts.Load())
+	assert.Equal(t, e2e.EncryptedSize(int64(len(data))), info.Size)
+	assert.Equal(t, e2e.SchemePassphrase, info.Metadata[e2e.MetaScheme])
+	assert.Equal(t, "infra", info.Metadata["team"])
+
+	out := filepath.Join(t.TempDir(), "out.bin")
+	_, err = New(c, Options{ChunkSize: testChunk}).Download(context.Background(), info.Key, out)
+	assert.ErrorContains(t, err, "needs a key")
+
+	var asked string
+	opts.DecryptionKey = func(scheme, keyID string) (*e2e.Key, error) {
+		asked = scheme
+		return passphrase, nil
+	}
+	_, err = New(c, opts).Download(context.Background(), info.Key, out)
+	require.NoError(t, err)
+	assert.Equal(t, e2e.SchemePassphrase, asked)
+	got, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, got))
+	assert.NoFileExists(t, out+".part")
+
+	// Small files are encrypted in a single request
+	small, smallData := writeRandomFile(t, 100)
+	info, err = New(c, opts).Upload(context.Background(), small, nil, nil)
+	require.NoError(t, err)
+	_, err = New(c, opts).Download(context.Background(), info.Key, out)
+	require.NoError(t, err)
+	got, err = os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, smallData, got)
+}
@@ -0,0 +1,232 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/pkg/e2e"
+)
+
+// uploadState lets a rerun continue an interrupted upload of the same file
+type uploadState struct {
+	UploadID  string    `json:"upload_id"`
+	Server    string    `json:"server"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+	ChunkSize int64     `json:"chunk_size"`
+	Encrypted bool      `json:"encrypted,omitempty"`
+	// Header starts the encryption of an encrypted upload; resuming with
+	// it yields the same ciphertext for the parts still missing
+	Header []byte `json:"header,omitempty"`
+}
+
+func (s *uploadState) matches(o *uploadState) bool {
+	return s.UploadID != "" && s.Server == o.Server && s.Path == o.Path && s.Size == o.Size &&
+		s.ModTime.Equal(o.ModTime) && s.ChunkSize == o.ChunkSize && s.Encrypted == o.Encrypted
+}
+
+// uploadSize is the size of what is uploaded of the file
+func (s *uploadState) uploadSize() int64 {
+	if s.Encrypted {
+		return e2e.EncryptedSize(s.Size)
+	}
+	return s.Size
+}
+
+// Upload stores the file at path. Files larger than one chunk are sent as
+// a resumable upload in parallel parts; if an earlier run of the same
+// upload was interrupted, only the missing parts are sent.
+func (e *Engine) Upload(ctx context.Context, path string, tags []string, metadata map[string]string) (*client.FileInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	if !stat.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+
+	name := filepath.Base(path)
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	want := &uploadState{
+		Server:    e.cluster.ServerURL(),
+		Path:      abs,
+		Size:      stat.Size(),
+		ModTime:   stat.ModTime(),
+		ChunkSize: e.opts.ChunkSize,
+		Encrypted: e.opts.Encryption != nil,
+	}
+	size := want.uploadSize()
+	if e.opts.Encryption != nil {
+		metadata = withMetadata(metadata, e.opts.Encryption.Metadata())
+	}
+	if size <= e.opts.ChunkSize {
+		content, _, err := e.content(file, stat.Size(), nil)
+		if err != nil {
+			return nil, err
+		}
+		t := newTracker(e.opts.OnProgress, size, 0)
+		return e.cluster.UploadData(ctx, name, "", &progressReader{r: io.NewSectionReader(content, 0, size), tracker: t}, tags, metadata)
+	}
+	statePath := e.uploadStatePath(want)
+
+	upload, err := e.resumeUpload(ctx, statePath, want)
+	if err != nil {
+		return nil, err
+	}
+	content, header, err := e.content(file, stat.Size(), want.Header)
+	if errors.Is(err, e2e.ErrWrongKey) {
+		return nil, fmt.Errorf("the interrupted upload of this file was encrypted with another key or passphrase: %w", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if upload == nil {
+		upload, err = e.cluster.CreateUpload(ctx, &client.UploadOptions{
+			Name:     name,
+			Size:     size,
+			PartSize: e.opts.ChunkSize,
+			Tags:     tags,
+			Metadata: metadata,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to start upload: %w", err)
+		}
+		want.UploadID = upload.ID
+		want.Header = header
+		if statePath != "" {
+			if err := saveState(statePath, want); err != nil {
+				return nil, fmt.Errorf("failed to save upload state: %w", err)
+			}
+		}
+	}
+
+	done := make(map[int]bool, len(upload.Parts))
+	var resumed int64
+	for _, part := range upload.Parts {
+		done[part] = true
+		resumed += min(e.opts.ChunkSize, size-int64(part)*e.opts.ChunkSize)
+	}
+	t := newTracker(e.opts.OnProgress, size, resumed)
+
+	err = e.run(ctx, chunks(size, e.opts.ChunkSize, done), func(ctx context.Context, c chunk) error {
+		data := make([]byte, c.length)
+		if _, err := content.ReadAt(data, c.offset); err != nil {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+		sum := sha256.Sum256(data)
+		body := &progressReader{r: bytes.NewReader(data), tracker: t}
+		if err := e.cluster.UploadPart(ctx, upload.ID, c.index, body, c.length, hex.EncodeToString(sum[:])); err != nil {
+			body.undo()
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		if statePath != "" {
+			return nil, fmt.Errorf("upload interrupted, run the command again to resume: %w", err)
+		}
+		return nil, err
+	}
+
+	info, err := e.cluster.CompleteUpload(ctx, upload.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete upload: %w", err)
+	}
+	if statePath != "" {
+		_ = os.Remove(statePath)
+	}
+	return info, nil
+}
+
+// resumeUpload returns the server's upload for an earlier interrupted run,
+// or nil when there is none to continue
+func (e *Engine) resumeUpload(ctx context.Context, statePath string, want *uploadState) (*client.Upload, error) {
+	if statePath == "" {
+		return nil, nil
+	}
+	var state uploadState
+	if !loadState(statePath, &state) {
+		return nil, nil
+	}
+	if !state.matches(want) {
+		return nil, nil
+	}
+
+	upload, err := e.cluster.GetUpload(ctx, state.UploadID)
+	if err != nil {
+		// The upload expired or the server lost it; start over
+		if client.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to resume upload: %w", err)
+	}
+	if upload.PartSize != e.opts.ChunkSize || upload.Size != want.uploadSize() || state.Encrypted && len(state.Header) == 0 {
+		return nil, nil
+	}
+	want.UploadID = upload.ID
+	want.Header = state.Header
+	return upload, nil
+}
+
+// content returns what is uploaded of the size bytes of file: the file
+// itself, or its encryption along with the header starting it. Passing the
+// header of an interrupted upload continues its encryption.
+func (e *Engine) content(file io.ReaderAt, size int64, header []byte) (io.ReaderAt, []byte, error) {
+	if e.opts.Encryption == nil {
+		return file, nil, nil
+	}
+	var (
+		enc *e2e.Encrypter
+		err error
+	)
+	if header == nil {
+		enc, err = e.opts.Encryption.NewEncrypter()
+	} else {
+		enc, err = e.opts.Encryption.ResumeEncrypter(header)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc.ReaderAt(file, size), enc.Header(), nil
+}
+
+// withMetadata returns metadata with extra added, leaving metadata as is
+func withMetadata(metadata, extra map[string]string) map[string]string {
+	merged := make(map[string]string, len(metadata)+len(extra))
+	for k, v := range metadata {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	return merged
+}
+
+// uploadStatePath names the state file of an upload after what identifies
+// it: the server, the file and its size and modification time
+func (e *Engine) uploadStatePath(s *uploadState) string {
+	if e.opts.StateDir == "" {
+		return ""
+	}
+	key := fmt.Sprintf("%s\n%s\n%d\n%d\n%d", s.Server, s.Path, s.Size, s.ModTime.UnixNano(), s.ChunkSize)
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(e.opts.StateDir, "upload-"+hex.EncodeToString(sum[:8])+".json")
+}
@@ -0,0 +1,33 @@
+// Package clientip finds the address of the client behind a request.
+// Requests relayed by a proxy on the same host, such as the node's API
+// gateway, name the client in X-Forwarded-For; requests from anywhere else
+// are taken at their connection's address, so remote clients cannot pick
+// the address they are rate limited and audited under.
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// FromRequest returns the IP address of the client of r
+func FromRequest(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return host
+	}
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		return host
+	}
+	// The local proxy appended the address it was reached from last
+	hops := strings.Split(forwarded[len(forwarded)-1], ",")
+	if last := strings.TrimSpace(hops[len(hops)-1]); net.ParseIP(last) != nil {
+		return last
+	}
+	return host
+}
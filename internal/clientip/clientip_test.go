@@ -0,0 +1,36 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "203.0.113.7:4000", nil, "203.0.113.7"},
+		{"remote clients cannot claim another address", "203.0.113.7:4000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"local proxy", "127.0.0.1:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"local proxy appends to the client's list", "[::1]:4000", []string{"10.0.0.1, 198.51.100.1"}, "198.51.100.1"},
+		{"last header wins", "127.0.0.1:4000", []string{"10.0.0.1", "198.51.100.1"}, "198.51.100.1"},
+		{"local client", "127.0.0.1:4000", nil, "127.0.0.1"},
+		{"garbage", "127.0.0.1:4000", []string{"not-an-ip"}, "127.0.0.1"},
+		{"no port", "203.0.113.7", nil, "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, f := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", f)
+			}
+			assert.Equal(t, tt.want, FromRequest(r))
+		})
+	}
+}
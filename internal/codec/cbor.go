@@ -0,0 +1,404 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// CBOR major types
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborSimple = 7
+)
+
+// Simple values and the float64 marker of major type 7
+const (
+	cborFalse   = 0xf4
+	cborTrue    = 0xf5
+	cborNull    = 0xf6
+	cborFloat64 = 0xfb
+)
+
+// cborMaxDepth bounds the nesting of items skipped as unknown fields
+const cborMaxDepth = 32
+
+var errCBORShort = errors.New("codec: CBOR data ends early")
+
+// cborCodec encodes a message as a CBOR map with a single entry from its
+// tag to the message. Messages are maps from field numbers to values,
+// leaving out fields of zero value; only definite lengths are used.
+type cborCodec struct{}
+
+func (cborCodec) ID() uint8    { return IDCBOR }
+func (cborCodec) Name() string { return "cbor" }
+
+func (cborCodec) Encode(msg any) ([]byte, error) {
+	tag, v, err := tagOf(msg)
+	if err != nil {
+		return nil, err
+	}
+	b := appendCBORHead(nil, cborMap, 1)
+	b = appendCBORHead(b, cborUint, tag)
+	return appendCBORStruct(b, v), nil
+}
+
+func (cborCodec) Decode(data []byte) (any, error) {
+	d := &cborDecoder{data: data}
+	major, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	if major != cborMap || n != 1 {
+		return nil, fmt.Errorf("codec: CBOR message is not a map with one entry")
+	}
+	tag, err := d.uint()
+	if err != nil {
+		return nil, err
+	}
+	t, err := typeOf(tag)
+	if err != nil {
+		return nil, err
+	}
+	v := reflect.New(t).Elem()
+	if err := d.decodeStruct(v); err != nil {
+		return nil, err
+	}
+	if len(d.data) != 0 {
+		return nil, fmt.Errorf("codec: trailing data after message")
+	}
+	return v.Interface(), nil
+}
+
+// appendCBORHead appends the head of an item of the given major type
+func appendCBORHead(b []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, major|27), n)
+}
+
+func appendCBORStruct(b []byte, v reflect.Value) []byte {
+	fields := 0
+	for i := range v.NumField() {
+		if !v.Field(i).IsZero() {
+			fields++
+		}
+	}
+	b = appendCBORHead(b, cborMap, uint64(fields))
+	for i := range v.NumField() {
+		if f := v.Field(i); !f.IsZero() {
+			b = appendCBORHead(b, cborUint, uint64(i+1))
+			b = appendCBORValue(b, f)
+		}
+	}
+	return b
+}
+
+func appendCBORValue(b []byte, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.String:
+		return append(appendCBORHead(b, cborText, uint64(v.Len())), v.String()...)
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, cborTrue)
+		}
+		return append(b, cborFalse)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		if x := v.Int(); x < 0 {
+			return appendCBORHead(b, cborNegInt, uint64(-1-x))
+		}
+		return appendCBORHead(b, cborUint, uint64(v.Int()))
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return appendCBORHead(b, cborUint, v.Uint())
+	case reflect.Float64:
+		return binary.BigEndian.AppendUint64(append(b, cborFloat64), math.Float64bits(v.Float()))
+	case reflect.Struct:
+		return appendCBORStruct(b, v)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return append(appendCBORHead(b, cborBytes, uint64(v.Len())), v.Bytes()...)
+		}
+		b = appendCBORHead(b, cborArray, uint64(v.Len()))
+		for i := range v.Len() {
+			b = appendCBORValue(b, v.Index(i))
+		}
+		return b
+	case reflect.Map:
+		keys := v.MapKeys()
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return strings.Compare(a.String(), b.String()) })
+		b = appendCBORHead(b, cborMap, uint64(len(keys)))
+		for _, key := range keys {
+			b = appendCBORValue(b, key)
+			b = appendCBORValue(b, v.MapIndex(key))
+		}
+		return b
+	}
+	return append(b, cborNull)
+}
+
+// cborDecoder reads items from the front of data
+type cborDecoder struct {
+	data []byte
+}
+
+// head reads the head of the next item, returning its major type and
+// argument. Indefinite lengths are refused.
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if len(d.data) == 0 {
+		return 0, 0, errCBORShort
+	}
+	major, info := d.data[0]>>5, d.data[0]&0x1f
+	d.data = d.data[1:]
+	if major == cborSimple {
+		// Simple values and floats keep their whole first byte
+		return major, uint64(info), nil
+	}
+	size := 0
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, fmt.Errorf("codec: unsupported CBOR length encoding %d", info)
+	}
+	if len(d.data) < size {
+		return 0, 0, errCBORShort
+	}
+	var n uint64
+	for _, c := range d.data[:size] {
+		n = n<<8 | uint64(c)
+	}
+	d.data = d.data[size:]
+	return major, n, nil
+}
+
+func (d *cborDecoder) uint() (uint64, error) {
+	major, n, err := d.head()
+	if err != nil {
+		return 0, err
+	}
+	if major != cborUint {
+		return 0, fmt.Errorf("codec: expected CBOR unsigned integer, got major type %d", major)
+	}
+	return n, nil
+}
+
+// take returns the next n bytes
+func (d *cborDecoder) take(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)) {
+		return nil, errCBORShort
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+// length reads the head of a container of the given major type, checking
+// its length against the data left, as every entry takes a byte at least
+func (d *cborDecoder) length(want byte) (int, error) {
+	major, n, err := d.head()
+	if err != nil {
+		return 0, err
+	}
+	if major != want {
+		return 0, fmt.Errorf("codec: expected CBOR major type %d, got %d", want, major)
+	}
+	if n > uint64(len(d.data)) {
+		return 0, errCBORShort
+	}
+	return int(n), nil
+}
+
+func (d *cborDecoder) decodeStruct(v reflect.Value) error {
+	n, err := d.length(cborMap)
+	if err != nil {
+		return err
+	}
+	for range n {
+		num, err := d.uint()
+		if err != nil {
+			return err
+		}
+		if num < 1 || num > uint64(v.NumField()) {
+			// A field of a later version
+			if err := d.skip(0); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := d.decodeValue(v.Field(int(num) - 1)); err != nil {
+			return fmt.Errorf("field %s: %w", v.Type().Field(int(num)-1).Name, err)
+		}
+	}
+	return nil
+}
+
+func (d *cborDecoder) decodeValue(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		return d.decodeStruct(v)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			n, err := d.length(cborBytes)
+			if err != nil {
+				return err
+			}
+			b, _ := d.take(uint64(n))
+			v.SetBytes(append([]byte(nil), b...))
+			return nil
+		}
+		n, err := d.length(cborArray)
+		if err != nil {
+			return err
+		}
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := range n {
+			if err := d.decodeValue(s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	case reflect.Map:
+		n, err := d.length(cborMap)
+		if err != nil {
+			return err
+		}
+		m := reflect.MakeMapWithSize(v.Type(), n)
+		for range n {
+			key := reflect.New(v.Type().Key()).Elem()
+			value := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decodeValue(key); err != nil {
+				return err
+			}
+			if err := d.decodeValue(value); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+		return nil
+	}
+	return d.decodeScalar(v)
+}
+
+func (d *cborDecoder) decodeScalar(v reflect.Value) error {
+	major, n, err := d.head()
+	if err != nil {
+		return err
+	}
+	switch v.Kind() {
+	case reflect.String:
+		if major != cborText {
+			break
+		}
+		b, err := d.take(n)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+		return nil
+	case reflect.Bool:
+		if major != cborSimple || (n != cborTrue&0x1f && n != cborFalse&0x1f) {
+			break
+		}
+		v.SetBool(n == cborTrue&0x1f)
+		return nil
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		if major == cborUint && n <= math.MaxInt64 {
+			v.SetInt(int64(n))
+			return nil
+		}
+		if major == cborNegInt && n <= math.MaxInt64 {
+			v.SetInt(-1 - int64(n))
+			return nil
+		}
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		if major != cborUint {
+			break
+		}
+		v.SetUint(n)
+		return nil
+	case reflect.Float64:
+		if major != cborSimple || n != cborFloat64&0x1f {
+			break
+		}
+		b, err := d.take(8)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(math.Float64frombits(binary.BigEndian.Uint64(b)))
+		return nil
+	}
+	return fmt.Errorf("codec: CBOR major type %d does not fit %v", major, v.Type())
+}
+
+// skip reads past the next item
+func (d *cborDecoder) skip(depth int) error {
+	if depth > cborMaxDepth {
+		return fmt.Errorf("codec: CBOR nested too deeply")
+	}
+	major, n, err := d.head()
+	if err != nil {
+		return err
+	}
+	switch major {
+	case cborBytes, cborText:
+		_, err = d.take(n)
+		return err
+	case cborArray, cborMap:
+		if n > uint64(len(d.data)) {
+			return errCBORShort
+		}
+		items := n
+		if major == cborMap {
+			items *= 2
+		}
+		for range items {
+			if err := d.skip(depth + 1); err != nil {
+				return err
+			}
+		}
+	case 6:
+		// A semantic tag applies to the item that follows
+		return d.skip(depth + 1)
+	case cborSimple:
+		switch n {
+		case 24:
+			_, err = d.take(1)
+		case 25:
+			_, err = d.take(2)
+		case 26:
+			_, err = d.take(4)
+		case 27:
+			_, err = d.take(8)
+		}
+		return err
+	}
+	return nil
+}
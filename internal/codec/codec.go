@@ -0,0 +1,191 @@
+// Package codec encodes the control messages peers exchange. Besides gob,
+// which only Go speaks, messages can travel as protobuf or CBOR, both
+// smaller on the wire and readable by implementations in other languages.
+// Peers agree on a codec in the handshake.
+//
+// Message types are registered with a tag, which protobuf and CBOR send to
+// tell them apart. Their fields are numbered in declaration order from 1,
+// so new fields must be appended and existing ones never removed or
+// reordered; proto/p2p.proto describes the resulting schema.
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// IDs of the codecs, as exchanged in the handshake and message envelopes
+const (
+	IDGob      uint8 = 1
+	IDProtobuf uint8 = 2
+	IDCBOR     uint8 = 3
+)
+
+// Codec encodes registered message types
+type Codec interface {
+	// ID identifies the codec on the wire
+	ID() uint8
+	// Name is a readable name of the codec
+	Name() string
+	// Encode encodes a value of a registered message type
+	Encode(msg any) ([]byte, error)
+	// Decode decodes a message, returning a value of its registered type
+	Decode(data []byte) (any, error)
+}
+
+var (
+	// Gob encodes messages as gob, the format of nodes predating codec
+	// negotiation
+	Gob Codec = gobCodec{}
+	// Protobuf encodes messages in the protobuf wire format
+	Protobuf Codec = protobufCodec{}
+	// CBOR encodes messages as CBOR (RFC 8949)
+	CBOR Codec = cborCodec{}
+)
+
+// Supported returns the IDs of the codecs this node speaks, most preferred
+// first
+func Supported() []uint8 {
+	return []uint8{IDProtobuf, IDCBOR, IDGob}
+}
+
+// ByID returns the codec with the given ID
+func ByID(id uint8) (Codec, bool) {
+	switch id {
+	case IDGob:
+		return Gob, true
+	case IDProtobuf:
+		return Protobuf, true
+	case IDCBOR:
+		return CBOR, true
+	}
+	return nil, false
+}
+
+// registry maps the registered message types to their tags and back
+var registry = struct {
+	sync.RWMutex
+	tags  map[reflect.Type]uint64
+	types map[uint64]reflect.Type
+}{tags: map[reflect.Type]uint64{}, types: map[uint64]reflect.Type{}}
+
+// Register makes the type of prototype, a struct value, encodable under
+// tag, and registers it with gob as well. Like gob.Register, it panics on
+// conflicting registrations and on types the codecs cannot encode.
+func Register(tag uint64, prototype any) {
+	t := reflect.TypeOf(prototype)
+	if t == nil || t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("codec: cannot register %T, only structs", prototype))
+	}
+	if tag == 0 {
+		panic(fmt.Sprintf("codec: tag of %v must be positive", t))
+	}
+	if err := checkType(t); err != nil {
+		panic(fmt.Sprintf("codec: cannot register %v: %v", t, err))
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+	if other, ok := registry.types[tag]; ok && other != t {
+		panic(fmt.Sprintf("codec: tag %d registered for %v and %v", tag, other, t))
+	}
+	if other, ok := registry.tags[t]; ok && other != tag {
+		panic(fmt.Sprintf("codec: %v registered with tags %d and %d", t, other, tag))
+	}
+	registry.tags[t], registry.types[tag] = tag, t
+	gob.Register(prototype)
+}
+
+// tagOf returns the tag of the type of msg
+func tagOf(msg any) (uint64, reflect.Value, error) {
+	v := reflect.ValueOf(msg)
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	registry.RLock()
+	tag, ok := registry.tags[v.Type()]
+	registry.RUnlock()
+	if !ok {
+		return 0, v, fmt.Errorf("codec: %T is not registered", msg)
+	}
+	return tag, v, nil
+}
+
+// typeOf returns the type registered under tag
+func typeOf(tag uint64) (reflect.Type, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+	t, ok := registry.types[tag]
+	if !ok {
+		return nil, fmt.Errorf("codec: unknown message tag %d", tag)
+	}
+	return t, nil
+}
+
+// checkType reports an error for fields protobuf and CBOR cannot carry
+func checkType(t reflect.Type) error {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			return fmt.Errorf("field %s is unexported", f.Name)
+		}
+		if err := checkField(f.Type); err != nil {
+			return fmt.Errorf("field %s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+func checkField(t reflect.Type) error {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint32, reflect.Uint64, reflect.Float64:
+		return nil
+	case reflect.Struct:
+		return checkType(t)
+	case reflect.Slice:
+		switch e := t.Elem(); e.Kind() {
+		case reflect.Uint8, reflect.String:
+			return nil
+		case reflect.Struct:
+			return checkType(e)
+		}
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			break
+		}
+		switch t.Elem().Kind() {
+		case reflect.String, reflect.Int64, reflect.Uint64:
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported type %v", t)
+}
+
+// gobMessage is the envelope gob encodes messages in, matching the
+// message struct of the file server field by field
+type gobMessage struct{ Payload any }
+
+type gobCodec struct{}
+
+func (gobCodec) ID() uint8    { return IDGob }
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Encode(msg any) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(gobMessage{Payload: msg}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Decode(data []byte) (any, error) {
+	var msg gobMessage
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&msg); err != nil {
+		return nil, err
+	}
+	return msg.Payload, nil
+}
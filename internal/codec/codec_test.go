@@ -0,0 +1,141 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type testLink struct {
+	To   string
+	Loss float64
+}
+
+type testMessage struct {
+	ID       string
+	Size     int64
+	Offset   int64
+	Seq      uint64
+	Final    bool
+	Data     []byte
+	Tags     []string
+	Metadata map[string]string
+	Version  map[string]uint64
+	Links    []testLink
+	Primary  testLink
+}
+
+type testOther struct {
+	Key string
+}
+
+func init() {
+	Register(1001, testMessage{})
+	Register(1002, testOther{})
+}
+
+func TestRoundTrip(t *testing.T) {
+	msg := testMessage{
+		ID:       "request-1",
+		Size:     1 << 40,
+		Offset:   -42,
+		Seq:      7,
+		Final:    true,
+		Data:     []byte{0, 1, 2, 255},
+		Tags:     []string{"a", "", "c"},
+		Metadata: map[string]string{"owner": "ops", "empty": ""},
+		Version:  map[string]uint64{"node-a": 3, "node-b": 0},
+		Links:    []testLink{{To: "x", Loss: 0.25}, {}},
+		Primary:  testLink{To: "y", Loss: -1.5},
+	}
+	for _, c := range []Codec{Gob, Protobuf, CBOR} {
+		t.Run(c.Name(), func(t *testing.T) {
+			data, err := c.Encode(msg)
+			require.NoError(t, err)
+			decoded, err := c.Decode(data)
+			require.NoError(t, err)
+			assert.Equal(t, msg, decoded)
+
+			// Empty messages decode to their zero value
+			data, err = c.Encode(testOther{})
+			require.NoError(t, err)
+			decoded, err = c.Decode(data)
+			require.NoError(t, err)
+			assert.Equal(t, testOther{}, decoded)
+
+			_, err = c.Encode(struct{ X int }{})
+			assert.Error(t, err)
+
+			found, ok := ByID(c.ID())
+			assert.True(t, ok)
+			assert.Equal(t, c, found)
+		})
+	}
+}
+
+func TestCompactEncodings(t *testing.T) {
+	msg := testMessage{ID: "request-1", Seq: 7, Metadata: map[string]string{"owner": "ops"}}
+	gobData, err := Gob.Encode(msg)
+	require.NoError(t, err)
+	for _, c := range []Codec{Protobuf, CBOR} {
+		data, err := c.Encode(msg)
+		require.NoError(t, err)
+		assert.Less(t, len(data), len(gobData)/2, c.Name())
+	}
+}
+
+func TestProtobufWireFormat(t *testing.T) {
+	// testOther{Key: "k"} is field 1002 holding a message with field 1
+	inner := protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), "k")
+	want := protowire.AppendBytes(protowire.AppendTag(nil, 1002, protowire.BytesType), inner)
+	data, err := Protobuf.Encode(testOther{Key: "k"})
+	require.NoError(t, err)
+	assert.Equal(t, want, data)
+
+	// Fields added by later versions are skipped
+	inner = protowire.AppendVarint(protowire.AppendTag(inner, 9, protowire.VarintType), 5)
+	decoded, err := Protobuf.Decode(protowire.AppendBytes(protowire.AppendTag(nil, 1002, protowire.BytesType), inner))
+	require.NoError(t, err)
+	assert.Equal(t, testOther{Key: "k"}, decoded)
+}
+
+func TestCBORWireFormat(t *testing.T) {
+	// {1002: {1: "k"}}
+	want := []byte{0xa1, 0x19, 0x03, 0xea, 0xa1, 0x01, 0x61, 'k'}
+	data, err := CBOR.Encode(testOther{Key: "k"})
+	require.NoError(t, err)
+	assert.Equal(t, want, data)
+
+	// Fields added by later versions are skipped: {1002: {9: [1, {2: h'00'}], 1: "k"}}
+	decoded, err := CBOR.Decode([]byte{0xa1, 0x19, 0x03, 0xea, 0xa2, 0x09, 0x82, 0x01, 0xa1, 0x02, 0x41, 0x00, 0x01, 0x61, 'k'})
+	require.NoError(t, err)
+	assert.Equal(t, testOther{Key: "k"}, decoded)
+}
+
+func TestMalformedInput(t *testing.T) {
+	inputs := [][]byte{
+		nil,
+		{0xa1},
+		{0xa1, 0x19, 0x03, 0xea, 0xa1, 0x01, 0x7a, 0xff, 0xff, 0xff, 0xff},
+		{0xa1, 0x19, 0x03, 0xea, 0xbb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		{0xa1, 0x19, 0x03, 0xea, 0xa1, 0x01, 0x01},
+		{0xa1, 0x19, 0x03, 0xea, 0xa1, 0x01, 0x61, 'k', 0x00},
+		{0xa1, 0x19, 0x27, 0x0f, 0xa0},
+		{0xff, 0x0f, 0xff},
+	}
+	for _, c := range []Codec{Gob, Protobuf, CBOR} {
+		for _, input := range inputs {
+			_, err := c.Decode(input)
+			assert.Error(t, err, "%s accepted %x", c.Name(), input)
+		}
+	}
+}
+
+func TestRegisterRejectsUnsupportedTypes(t *testing.T) {
+	assert.Panics(t, func() { Register(2001, struct{ C chan int }{}) })
+	assert.Panics(t, func() { Register(2002, &testOther{}) })
+	assert.Panics(t, func() { Register(1001, testOther{}) })
+	assert.NotPanics(t, func() { Register(1002, testOther{}) })
+}
@@ -0,0 +1,261 @@
+package codec
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protobufCodec encodes a message as a protobuf message holding it in the
+// field numbered by its tag, like a oneof. Fields of zero value are left
+// out, as proto3 does.
+type protobufCodec struct{}
+
+func (protobufCodec) ID() uint8    { return IDProtobuf }
+func (protobufCodec) Name() string { return "protobuf" }
+
+func (protobufCodec) Encode(msg any) ([]byte, error) {
+	tag, v, err := tagOf(msg)
+	if err != nil {
+		return nil, err
+	}
+	b := protowire.AppendTag(nil, protowire.Number(tag), protowire.BytesType)
+	return protowire.AppendBytes(b, appendProtoStruct(nil, v)), nil
+}
+
+func (protobufCodec) Decode(data []byte) (any, error) {
+	num, typ, n := protowire.ConsumeTag(data)
+	if n < 0 {
+		return nil, protowire.ParseError(n)
+	}
+	if typ != protowire.BytesType {
+		return nil, fmt.Errorf("codec: message field %d has wire type %d", num, typ)
+	}
+	body, m := protowire.ConsumeBytes(data[n:])
+	if m < 0 {
+		return nil, protowire.ParseError(m)
+	}
+	if n+m != len(data) {
+		return nil, fmt.Errorf("codec: trailing data after message")
+	}
+	t, err := typeOf(uint64(num))
+	if err != nil {
+		return nil, err
+	}
+	v := reflect.New(t).Elem()
+	if err := consumeProtoStruct(body, v); err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+func appendProtoStruct(b []byte, v reflect.Value) []byte {
+	for i := range v.NumField() {
+		b = appendProtoField(b, protowire.Number(i+1), v.Field(i))
+	}
+	return b
+}
+
+func appendProtoField(b []byte, num protowire.Number, v reflect.Value) []byte {
+	if v.IsZero() {
+		return b
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		switch v.Type().Elem().Kind() {
+		case reflect.Uint8:
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			return protowire.AppendBytes(b, v.Bytes())
+		case reflect.String:
+			for i := range v.Len() {
+				b = protowire.AppendTag(b, num, protowire.BytesType)
+				b = protowire.AppendString(b, v.Index(i).String())
+			}
+		default:
+			for i := range v.Len() {
+				b = protowire.AppendTag(b, num, protowire.BytesType)
+				b = protowire.AppendBytes(b, appendProtoStruct(nil, v.Index(i)))
+			}
+		}
+		return b
+	case reflect.Map:
+		// Map entries are messages of the key, field 1, and the value,
+		// field 2; keys are sorted so encodings are deterministic
+		keys := v.MapKeys()
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return strings.Compare(a.String(), b.String()) })
+		for _, key := range keys {
+			entry := protowire.AppendTag(nil, 1, protowire.BytesType)
+			entry = protowire.AppendString(entry, key.String())
+			entry = appendProtoScalar(entry, 2, v.MapIndex(key))
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendBytes(b, entry)
+		}
+		return b
+	case reflect.Struct:
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, appendProtoStruct(nil, v))
+	}
+	return appendProtoScalar(b, num, v)
+}
+
+func appendProtoScalar(b []byte, num protowire.Number, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.String:
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendString(b, v.String())
+	case reflect.Bool:
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		return protowire.AppendVarint(b, protowire.EncodeBool(v.Bool()))
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		return protowire.AppendVarint(b, uint64(v.Int()))
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		return protowire.AppendVarint(b, v.Uint())
+	case reflect.Float64:
+		b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+		return protowire.AppendFixed64(b, math.Float64bits(v.Float()))
+	}
+	return b
+}
+
+func consumeProtoStruct(b []byte, v reflect.Value) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num < 1 || int(num) > v.NumField() {
+			// A field of a later version
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		field := v.Field(int(num) - 1)
+		n, err := consumeProtoField(b, typ, field)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", v.Type().Field(int(num)-1).Name, err)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// consumeProtoField reads the value of field from b, returning its length
+func consumeProtoField(b []byte, typ protowire.Type, field reflect.Value) (int, error) {
+	switch field.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Struct:
+		if typ != protowire.BytesType {
+			return 0, fmt.Errorf("unexpected wire type %d", typ)
+		}
+		data, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		return n, setProtoComposite(data, field)
+	}
+	return consumeProtoScalar(b, typ, field)
+}
+
+func setProtoComposite(data []byte, field reflect.Value) error {
+	switch field.Kind() {
+	case reflect.Struct:
+		return consumeProtoStruct(data, field)
+	case reflect.Map:
+		if field.IsNil() {
+			field.Set(reflect.MakeMap(field.Type()))
+		}
+		key := reflect.New(field.Type().Key()).Elem()
+		value := reflect.New(field.Type().Elem()).Elem()
+		for len(data) > 0 {
+			num, typ, n := protowire.ConsumeTag(data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			data = data[n:]
+			switch num {
+			case 1:
+				n, err := consumeProtoScalar(data, typ, key)
+				if err != nil {
+					return err
+				}
+				data = data[n:]
+			case 2:
+				n, err := consumeProtoScalar(data, typ, value)
+				if err != nil {
+					return err
+				}
+				data = data[n:]
+			default:
+				if n = protowire.ConsumeFieldValue(num, typ, data); n < 0 {
+					return protowire.ParseError(n)
+				}
+				data = data[n:]
+			}
+		}
+		field.SetMapIndex(key, value)
+		return nil
+	}
+	switch field.Type().Elem().Kind() {
+	case reflect.Uint8:
+		field.SetBytes(append([]byte(nil), data...))
+	case reflect.String:
+		field.Set(reflect.Append(field, reflect.ValueOf(string(data)).Convert(field.Type().Elem())))
+	default:
+		elem := reflect.New(field.Type().Elem()).Elem()
+		if err := consumeProtoStruct(data, elem); err != nil {
+			return err
+		}
+		field.Set(reflect.Append(field, elem))
+	}
+	return nil
+}
+
+func consumeProtoScalar(b []byte, typ protowire.Type, v reflect.Value) (int, error) {
+	want := protowire.VarintType
+	switch v.Kind() {
+	case reflect.String:
+		want = protowire.BytesType
+	case reflect.Float64:
+		want = protowire.Fixed64Type
+	}
+	if typ != want {
+		return 0, fmt.Errorf("unexpected wire type %d", typ)
+	}
+	switch want {
+	case protowire.BytesType:
+		data, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		v.SetString(string(data))
+		return n, nil
+	case protowire.Fixed64Type:
+		bits, n := protowire.ConsumeFixed64(b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		v.SetFloat(math.Float64frombits(bits))
+		return n, nil
+	}
+	x, n := protowire.ConsumeVarint(b)
+	if n < 0 {
+		return 0, protowire.ParseError(n)
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(protowire.DecodeBool(x))
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(x))
+	default:
+		v.SetUint(x)
+	}
+	return n, nil
+}
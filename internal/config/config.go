@@ -38,13 +38,73 @@ type Config struct {
 
 	// Performance configuration
 	Performance PerformanceConfig `yaml:"performance" json:"performance"`
+
+	// Chaos testing configuration
+	Chaos ChaosConfig `yaml:"chaos" json:"chaos"`
+
+	// Profiling and runtime diagnostics
+	Diagnostics DiagnosticsConfig `yaml:"diagnostics" json:"diagnostics"`
+
+	// Raft group holding the cluster metadata
+	Consensus ConsensusConfig `yaml:"consensus" json:"consensus"`
+
+	// Outgoing notifications such as emailed reports
+	Notifications NotificationsConfig `yaml:"notifications" json:"notifications"`
+
+	// Content scanning of stored files
+	Scan ScanConfig `yaml:"scan" json:"scan"`
+
+	// Rules evaluated on storing, replicating and sharing files
+	Policy PolicyConfig `yaml:"policy" json:"policy"`
+
+	// Thumbnails and dimensions of stored images and videos
+	Media MediaConfig `yaml:"media" json:"media"`
+
+	// Schemas of the JSON document API
+	JSON JSONConfig `yaml:"json" json:"json"`
+
+	// Append-only logs of records
+	Logs LogsConfig `yaml:"logs" json:"logs"`
+
+	// Durable pub/sub topics kept in logs
+	Topics TopicsConfig `yaml:"topics" json:"topics"`
+
+	// Pull-through caching of an upstream cluster
+	Edge EdgeConfig `yaml:"edge" json:"edge"`
+
+	// Routing clients to the nearest node of a multi-region cluster
+	Geo GeoConfig `yaml:"geo" json:"geo"`
+
+	// Client certificates of IoT devices
+	Devices DevicesConfig `yaml:"devices" json:"devices"`
+
+	// Staged firmware rollouts to IoT devices
+	Firmware FirmwareConfig `yaml:"firmware" json:"firmware"`
+
+	// Task handlers the node runs for its peers
+	Tasks TasksConfig `yaml:"tasks" json:"tasks"`
+
+	// Executables extending the node with storage backends, auth
+	// providers, content scanners and event sinks
+	Plugins PluginsConfig `yaml:"plugins" json:"plugins"`
+
+	// WebAssembly hooks run on the lifecycle of requests
+	Hooks HooksConfig `yaml:"hooks" json:"hooks"`
+
+	// CSV and Parquet exports of metadata, accesses and rollups
+	Exports ExportsConfig `yaml:"exports" json:"exports"`
 }
 
 // ServerConfig contains server-specific configuration
 type ServerConfig struct {
-	// Node ID (auto-generated if not provided)
+	// Node ID overriding the ID derived from the node's identity key. Nodes
+	// with an overridden ID do not prove an identity key to their peers.
 	NodeID string `yaml:"node_id" json:"node_id" env:"PEERVAULT_NODE_ID"`
 
+	// File holding the node's identity key, created on first start; empty
+	// uses identity.key under the storage root
+	IdentityFile string `yaml:"identity_file" json:"identity_file" env:"PEERVAULT_IDENTITY_FILE"`
+
 	// Listen address for the server
 	ListenAddr string `yaml:"listen_addr" json:"listen_addr" env:"PEERVAULT_LISTEN_ADDR" default:":3000"`
 
@@ -77,6 +137,23 @@ type StorageConfig struct {
 
 	// Retention period for deleted files
 	RetentionPeriod time.Duration `yaml:"retention_period" json:"retention_period" env:"PEERVAULT_RETENTION_PERIOD" default:"24h"`
+
+	// Number of copies of each file, this node's included
+	ReplicationFactor int `yaml:"replication_factor" json:"replication_factor" env:"PEERVAULT_REPLICATION_FACTOR" default:"3"`
+
+	// Storage capacity in bytes; zero means the size of the filesystem
+	// holding the storage root. A node's share of the files follows the
+	// free space it has left of it.
+	Capacity int64 `yaml:"capacity" json:"capacity" env:"PEERVAULT_STORAGE_CAPACITY" default:"0"`
+
+	// Fraction of the capacity in use above which the node refuses new
+	// files and replicas
+	HighWatermark float64 `yaml:"high_watermark" json:"high_watermark" env:"PEERVAULT_STORAGE_HIGH_WATERMARK" default:"0.9"`
+
+	// Hash naming object paths and chunks: sha1 (the layout stores have
+	// always used), sha256 or blake3. Objects written under an earlier
+	// choice stay readable and move to the new one as they are read.
+	Hash string `yaml:"hash" json:"hash" env:"PEERVAULT_STORAGE_HASH" default:"sha1"`
 }
 
 // NetworkConfig contains network-specific configuration
@@ -84,6 +161,17 @@ type NetworkConfig struct {
 	// Bootstrap nodes (comma-separated)
 	BootstrapNodes []string `yaml:"bootstrap_nodes" json:"bootstrap_nodes" env:"PEERVAULT_BOOTSTRAP_NODES"`
 
+	// DNS names listing peers (comma-separated): SRV names such as
+	// _peervault._tcp.example.com, host:port names whose addresses are
+	// peers, or names whose TXT records list host:port entries
+	Seeds []string `yaml:"seeds" json:"seeds" env:"PEERVAULT_SEEDS"`
+
+	// How often seeds and the rendezvous service are listed
+	SeedInterval time.Duration `yaml:"seed_interval" json:"seed_interval" env:"PEERVAULT_SEED_INTERVAL" default:"1m"`
+
+	// Rendezvous service nodes register with to find each other
+	Rendezvous RendezvousConfig `yaml:"rendezvous" json:"rendezvous"`
+
 	// Connection timeout
 	ConnectionTimeout time.Duration `yaml:"connection_timeout" json:"connection_timeout" env:"PEERVAULT_CONNECTION_TIMEOUT" default:"30s"`
 
@@ -98,6 +186,136 @@ type NetworkConfig struct {
 
 	// Maximum message size
 	MaxMessageSize int64 `yaml:"max_message_size" json:"max_message_size" env:"PEERVAULT_MAX_MESSAGE_SIZE" default:"1048576"` // 1MB
+
+	// How often peers are probed for round trip times
+	LatencyProbeInterval time.Duration `yaml:"latency_probe_interval" json:"latency_probe_interval" env:"PEERVAULT_LATENCY_PROBE_INTERVAL" default:"10s"`
+
+	// Which peers may connect
+	ACL PeerACLConfig `yaml:"acl" json:"acl"`
+
+	// How fast peers may connect and send messages
+	Limits ConnectionLimitsConfig `yaml:"limits" json:"limits"`
+
+	// Swarm downloads of large files
+	Swarm SwarmConfig `yaml:"swarm" json:"swarm"`
+
+	// Discovery of nodes on the local network
+	MDNS MDNSConfig `yaml:"mdns" json:"mdns"`
+
+	// Admission of new nodes with join tokens
+	Join JoinConfig `yaml:"join" json:"join"`
+}
+
+// JoinConfig configures joining the cluster with join tokens. Nodes with
+// join enabled keep the cluster credentials, the secret signing handshakes
+// and the cluster key, in cluster-credentials.json under the storage root
+// and admit the nodes presenting a token created through the API.
+type JoinConfig struct {
+	// Admit nodes with join tokens; the first node of a cluster creates
+	// the credentials from PEERVAULT_AUTH_TOKEN and security.cluster_key,
+	// or random ones
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_JOIN_ENABLED" default:"false"`
+
+	// Token to join the cluster with when the node has no credentials yet
+	Token string `yaml:"token" json:"token" env:"PEERVAULT_JOIN_TOKEN"`
+
+	// How long tokens created through the API are valid unless asked
+	// otherwise
+	TokenTTL time.Duration `yaml:"token_ttl" json:"token_ttl" env:"PEERVAULT_JOIN_TOKEN_TTL" default:"24h"`
+
+	// Address joining nodes reach this node at; the listen address when
+	// empty, with the host name when it has no host
+	AdvertiseAddr string `yaml:"advertise_addr" json:"advertise_addr" env:"PEERVAULT_JOIN_ADVERTISE_ADDR"`
+}
+
+// RendezvousConfig configures the rendezvous service the node registers
+// with. Peers it lists still pass the handshake and the peer ACL.
+type RendezvousConfig struct {
+	// Base URL of the service; empty disables it
+	URL string `yaml:"url" json:"url" env:"PEERVAULT_RENDEZVOUS_URL"`
+
+	// Bearer token the service requires
+	Token string `yaml:"token" json:"token" env:"PEERVAULT_RENDEZVOUS_TOKEN"`
+
+	// Cluster registered in; only nodes of the same cluster are listed
+	Cluster string `yaml:"cluster" json:"cluster" env:"PEERVAULT_RENDEZVOUS_CLUSTER"`
+
+	// Address peers reach this node at; the listen address when empty. An
+	// address without a host, such as :3000, is completed by the service
+	// with the host the node connects from.
+	AdvertiseAddr string `yaml:"advertise_addr" json:"advertise_addr" env:"PEERVAULT_RENDEZVOUS_ADVERTISE_ADDR"`
+}
+
+// MDNSConfig configures the discovery of nodes on the local network with
+// mDNS/DNS-SD. Discovered nodes still pass the handshake and the peer ACL.
+type MDNSConfig struct {
+	// Announce this node and connect to the nodes found
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_MDNS_ENABLED" default:"false"`
+
+	// Only nodes announcing the same cluster name are connected to
+	Cluster string `yaml:"cluster" json:"cluster" env:"PEERVAULT_MDNS_CLUSTER"`
+
+	// DNS-SD service type announced
+	Service string `yaml:"service" json:"service" env:"PEERVAULT_MDNS_SERVICE" default:"_peervault._tcp"`
+
+	// How often the network is queried for nodes
+	Interval time.Duration `yaml:"interval" json:"interval" env:"PEERVAULT_MDNS_INTERVAL" default:"30s"`
+}
+
+// SwarmConfig tunes swarm downloads, which fetch large files in pieces
+// from every peer holding them at once
+type SwarmConfig struct {
+	// Size in bytes from which files are fetched as a swarm; 0 uses the
+	// default and a negative size fetches every file from one peer
+	MinSize int64 `yaml:"min_size" json:"min_size" env:"PEERVAULT_SWARM_MIN_SIZE" default:"8388608"`
+
+	// Bytes per second a download fetches from each peer; 0 is unbounded
+	PeerRate int64 `yaml:"peer_rate" json:"peer_rate" env:"PEERVAULT_SWARM_PEER_RATE" default:"0"`
+}
+
+// PeerACLConfig lists the peers allowed or denied to connect. Denials win;
+// once anything is allowed, only allowed peers get in. Rules added through
+// the API come on top.
+type PeerACLConfig struct {
+	// Node IDs allowed to connect
+	AllowNodes []string `yaml:"allow_nodes" json:"allow_nodes" env:"PEERVAULT_PEER_ACL_ALLOW_NODES"`
+
+	// Node IDs denied
+	DenyNodes []string `yaml:"deny_nodes" json:"deny_nodes" env:"PEERVAULT_PEER_ACL_DENY_NODES"`
+
+	// Networks or addresses allowed to connect, such as 10.0.0.0/8
+	AllowCIDRs []string `yaml:"allow_cidrs" json:"allow_cidrs" env:"PEERVAULT_PEER_ACL_ALLOW_CIDRS"`
+
+	// Networks or addresses denied
+	DenyCIDRs []string `yaml:"deny_cidrs" json:"deny_cidrs" env:"PEERVAULT_PEER_ACL_DENY_CIDRS"`
+
+	// Deny peers that do not prove an identity key
+	RequireIdentity bool `yaml:"require_identity" json:"require_identity" env:"PEERVAULT_PEER_ACL_REQUIRE_IDENTITY" default:"false"`
+}
+
+// ConnectionLimitsConfig protects the peer transport from misbehaving
+// clients. Zero uses the transport default; negative turns a limit off.
+type ConnectionLimitsConfig struct {
+	// Connections one IP may open per second
+	ConnectionRate float64 `yaml:"connection_rate" json:"connection_rate" env:"PEERVAULT_PEER_CONNECTION_RATE" default:"20"`
+
+	// Connections one IP may open in a burst
+	ConnectionBurst int `yaml:"connection_burst" json:"connection_burst" env:"PEERVAULT_PEER_CONNECTION_BURST" default:"50"`
+
+	// Open inbound connections per IP
+	MaxConnectionsPerIP int `yaml:"max_connections_per_ip" json:"max_connections_per_ip" env:"PEERVAULT_PEER_MAX_CONNECTIONS_PER_IP" default:"64"`
+
+	// Inbound handshakes in progress at once
+	MaxHandshakes int `yaml:"max_handshakes" json:"max_handshakes" env:"PEERVAULT_PEER_MAX_HANDSHAKES" default:"128"`
+
+	// Time a peer has to complete the handshake
+	HandshakeTimeout time.Duration `yaml:"handshake_timeout" json:"handshake_timeout" env:"PEERVAULT_PEER_HANDSHAKE_TIMEOUT" default:"10s"`
+
+	// Messages a peer may send per second before it is dropped
+	MessageRate float64 `yaml:"message_rate" json:"message_rate" env:"PEERVAULT_PEER_MESSAGE_RATE" default:"2000"`
+
+	// Messages a peer may send in a burst
+	MessageBurst int `yaml:"message_burst" json:"message_burst" env:"PEERVAULT_PEER_MESSAGE_BURST" default:"4000"`
 }
 
 // SecurityConfig contains security-specific configuration
@@ -108,7 +326,7 @@ type SecurityConfig struct {
 	// Authentication token
 	AuthToken string `yaml:"auth_token" json:"auth_token" env:"PEERVAULT_AUTH_TOKEN" default:"demo-token"`
 
-	// Enable TLS
+	// Serve the HTTP APIs over TLS, with the certificate files or ACME
 	TLS bool `yaml:"tls" json:"tls" env:"PEERVAULT_TLS" default:"false"`
 
 	// TLS certificate file
@@ -128,6 +346,38 @@ type SecurityConfig struct {
 
 	// Allow demo tokens in production
 	AllowDemoToken bool `yaml:"allow_demo_token" json:"allow_demo_token" env:"PEERVAULT_ALLOW_DEMO_TOKEN" default:"true"`
+
+	// Automatic TLS certificates, used instead of the certificate files
+	ACME ACMEConfig `yaml:"acme" json:"acme"`
+
+	// Bytes per second re-encrypted after a key rotation; negative is unbounded
+	ReencryptionRate int64 `yaml:"reencryption_rate" json:"reencryption_rate" env:"PEERVAULT_REENCRYPTION_RATE" default:"8388608"`
+}
+
+// ACMEConfig contains the settings for obtaining and renewing TLS
+// certificates automatically from an ACME CA such as Let's Encrypt
+type ACMEConfig struct {
+	// Obtain certificates over ACME
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_ACME_ENABLED" default:"false"`
+
+	// Domains to obtain certificates for; they must resolve to this node
+	Domains []string `yaml:"domains" json:"domains" env:"PEERVAULT_ACME_DOMAINS"`
+
+	// Contact email for expiry and account notices
+	Email string `yaml:"email" json:"email" env:"PEERVAULT_ACME_EMAIL"`
+
+	// Directory keeping the account key and certificates across restarts
+	CacheDir string `yaml:"cache_dir" json:"cache_dir" env:"PEERVAULT_ACME_CACHE_DIR" default:"acme-cache"`
+
+	// ACME directory URL of the CA; empty uses Let's Encrypt
+	DirectoryURL string `yaml:"directory_url" json:"directory_url" env:"PEERVAULT_ACME_DIRECTORY_URL"`
+
+	// Address answering HTTP-01 challenges and redirecting plain HTTP to
+	// HTTPS; empty leaves only TLS-ALPN-01 on the API ports
+	HTTPAddr string `yaml:"http_addr" json:"http_addr" env:"PEERVAULT_ACME_HTTP_ADDR" default:":80"`
+
+	// How long before expiry certificates are renewed
+	RenewBefore time.Duration `yaml:"renew_before" json:"renew_before" env:"PEERVAULT_ACME_RENEW_BEFORE" default:"720h"`
 }
 
 // LoggingConfig contains logging-specific configuration
@@ -176,6 +426,36 @@ type APIConfig struct {
 
 	// gRPC API configuration
 	GRPC GRPCConfig `yaml:"grpc" json:"grpc"`
+
+	// WebSocket API configuration
+	WebSocket WebSocketConfig `yaml:"websocket" json:"websocket"`
+
+	// Server-Sent Events API configuration
+	SSE SSEConfig `yaml:"sse" json:"sse"`
+
+	// MQTT broker configuration
+	MQTT MQTTConfig `yaml:"mqtt" json:"mqtt"`
+
+	// CoAP API configuration
+	CoAP CoAPConfig `yaml:"coap" json:"coap"`
+
+	// Kafka wire-protocol listener serving durable topics
+	Kafka KafkaConfig `yaml:"kafka" json:"kafka"`
+
+	// NFS gateway exporting namespaces of the REST API's files
+	NFS NFSConfig `yaml:"nfs" json:"nfs"`
+
+	// SFTP and scp server for the REST API's files
+	SFTP SFTPConfig `yaml:"sftp" json:"sftp"`
+
+	// Container registry served by the REST API
+	Registry RegistryConfig `yaml:"registry" json:"registry"`
+
+	// CSRF protection and security headers of the HTTP APIs
+	HTTPSecurity HTTPSecurityConfig `yaml:"http_security" json:"http_security"`
+
+	// API gateway serving the HTTP APIs on one port
+	Gateway GatewayConfig `yaml:"gateway" json:"gateway"`
 }
 
 // RESTConfig contains REST API configuration
@@ -231,10 +511,267 @@ type GRPCConfig struct {
 	// Enable reflection
 	EnableReflection bool `yaml:"enable_reflection" json:"enable_reflection" env:"PEERVAULT_GRPC_REFLECTION" default:"true"`
 
+	// Enable channelz
+	EnableChannelz bool `yaml:"enable_channelz" json:"enable_channelz" env:"PEERVAULT_GRPC_CHANNELZ" default:"false"`
+
+	// Require the auth token or a JWT on gRPC calls other than health checks
+	RequireAuth bool `yaml:"require_auth" json:"require_auth" env:"PEERVAULT_GRPC_REQUIRE_AUTH" default:"false"`
+
+	// Secret signing HS256 JWTs; empty accepts the auth token only
+	JWTSecret string `yaml:"jwt_secret" json:"jwt_secret" env:"PEERVAULT_GRPC_JWT_SECRET"`
+
+	// Requests per second each client may make; 0 disables rate limiting
+	RateLimit int `yaml:"rate_limit" json:"rate_limit" env:"PEERVAULT_GRPC_RATE_LIMIT" default:"0"`
+
+	// Requests a client may make in a burst above the rate limit
+	RateLimitBurst int `yaml:"rate_limit_burst" json:"rate_limit_burst" env:"PEERVAULT_GRPC_RATE_LIMIT_BURST" default:"0"`
+
+	// Log every gRPC call with its request ID
+	LogRequests bool `yaml:"log_requests" json:"log_requests" env:"PEERVAULT_GRPC_LOG_REQUESTS" default:"true"`
+
 	// Maximum concurrent streams
 	MaxConcurrentStreams int `yaml:"max_concurrent_streams" json:"max_concurrent_streams" env:"PEERVAULT_GRPC_MAX_STREAMS" default:"100"`
 }
 
+// WebSocketConfig contains WebSocket API configuration
+type WebSocketConfig struct {
+	// Enable WebSocket API
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_WEBSOCKET_ENABLED" default:"false"`
+
+	// WebSocket API port
+	Port int `yaml:"port" json:"port" env:"PEERVAULT_WEBSOCKET_PORT" default:"8083"`
+
+	// Allowed origins for CORS
+	AllowedOrigins []string `yaml:"allowed_origins" json:"allowed_origins" env:"PEERVAULT_WEBSOCKET_ALLOWED_ORIGINS" default:"*"`
+}
+
+// SSEConfig contains Server-Sent Events API configuration
+type SSEConfig struct {
+	// Enable SSE API
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_SSE_ENABLED" default:"false"`
+
+	// SSE API port
+	Port int `yaml:"port" json:"port" env:"PEERVAULT_SSE_PORT" default:"8084"`
+
+	// Allowed origins for CORS
+	AllowedOrigins []string `yaml:"allowed_origins" json:"allowed_origins" env:"PEERVAULT_SSE_ALLOWED_ORIGINS" default:"*"`
+
+	// Events kept for clients resuming with Last-Event-ID; 0 disables replay
+	HistorySize int `yaml:"history_size" json:"history_size" env:"PEERVAULT_SSE_HISTORY_SIZE" default:"1000"`
+}
+
+// MQTTConfig contains MQTT broker configuration
+type MQTTConfig struct {
+	// Enable MQTT broker
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_MQTT_ENABLED" default:"false"`
+
+	// MQTT TCP port
+	Port int `yaml:"port" json:"port" env:"PEERVAULT_MQTT_PORT" default:"1883"`
+
+	// Enable MQTT over WebSocket
+	EnableWebSocket bool `yaml:"enable_websocket" json:"enable_websocket" env:"PEERVAULT_MQTT_ENABLE_WEBSOCKET" default:"false"`
+
+	// MQTT over WebSocket port
+	WebSocketPort int `yaml:"websocket_port" json:"websocket_port" env:"PEERVAULT_MQTT_WEBSOCKET_PORT" default:"8085"`
+
+	// Serve MQTT over TLS and require the certificates devices enroll for
+	// with the device CA; needs security.tls and devices.enabled
+	DeviceCertificates bool `yaml:"device_certificates" json:"device_certificates" env:"PEERVAULT_MQTT_DEVICE_CERTIFICATES" default:"false"`
+
+	// YAML or JSON file of topic ACL rules; rules can also be added
+	// through the REST API
+	ACLFile string `yaml:"acl_file" json:"acl_file" env:"PEERVAULT_MQTT_ACL_FILE"`
+}
+
+// GatewayConfig contains the settings of the API gateway, which serves the
+// REST, GraphQL, WebSocket and SSE APIs, and optionally a federation
+// gateway, on one port
+type GatewayConfig struct {
+	// Enable the API gateway
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_GATEWAY_ENABLED" default:"false"`
+
+	// Gateway port
+	Port int `yaml:"port" json:"port" env:"PEERVAULT_GATEWAY_PORT" default:"8000"`
+
+	// Bearer token required on every request but health checks, docs and
+	// public links; empty leaves authentication to the APIs
+	AuthToken string `yaml:"auth_token" json:"auth_token" env:"PEERVAULT_GATEWAY_AUTH_TOKEN"`
+
+	// Requests per minute per client IP; 0 disables rate limiting
+	RateLimitPerMin int `yaml:"rate_limit_per_min" json:"rate_limit_per_min" env:"PEERVAULT_GATEWAY_RATE_LIMIT_PER_MIN" default:"600"`
+
+	// URL of a federation gateway served under /federation/
+	FederationURL string `yaml:"federation_url" json:"federation_url" env:"PEERVAULT_GATEWAY_FEDERATION_URL"`
+
+	// Hosts route whole host names to one API, e.g. graphql:
+	// graphql.example.com; the API names are rest, graphql, websocket, sse
+	// and federation
+	Hosts map[string]string `yaml:"hosts" json:"hosts"`
+}
+
+// CoAPConfig contains CoAP API configuration
+type CoAPConfig struct {
+	// Enable CoAP API
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_COAP_ENABLED" default:"false"`
+
+	// CoAP UDP port
+	Port int `yaml:"port" json:"port" env:"PEERVAULT_COAP_PORT" default:"5683"`
+
+	// Serve the LwM2M registration interface at /rd and manage the
+	// devices registering there; with devices enabled only registered
+	// devices may register
+	LwM2M bool `yaml:"lwm2m" json:"lwm2m" env:"PEERVAULT_COAP_LWM2M" default:"false"`
+}
+
+// KafkaConfig contains the settings of the Kafka listener, which serves the
+// durable topics to Kafka producers and consumers
+type KafkaConfig struct {
+	// Enable the Kafka listener; needs durable topics
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_KAFKA_ENABLED" default:"false"`
+
+	// Kafka TCP port
+	Port int `yaml:"port" json:"port" env:"PEERVAULT_KAFKA_PORT" default:"9092"`
+
+	// Prefix prepended to Kafka topics to name their durable topic, with
+	// dots in Kafka topics becoming slashes
+	Prefix string `yaml:"prefix" json:"prefix" env:"PEERVAULT_KAFKA_PREFIX" default:"durable/"`
+
+	// host:port clients are told to connect to; empty tells them the
+	// address they connected to
+	AdvertisedAddr string `yaml:"advertised_addr" json:"advertised_addr" env:"PEERVAULT_KAFKA_ADVERTISED_ADDR"`
+}
+
+// NFSConfig contains the settings of the NFS gateway, which serves
+// namespaces of the files of the REST API as NFSv3 exports
+type NFSConfig struct {
+	// Enable the NFS gateway; needs the REST API
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_NFS_ENABLED" default:"false"`
+
+	// NFS TCP port, which serves MOUNT too
+	Port int `yaml:"port" json:"port" env:"PEERVAULT_NFS_PORT" default:"2049"`
+
+	// How long listings and attributes are reused before the files are
+	// listed again; 0 lists them for every call
+	AttrCacheTTL time.Duration `yaml:"attr_cache_ttl" json:"attr_cache_ttl" env:"PEERVAULT_NFS_ATTR_CACHE_TTL" default:"3s"`
+
+	// Writes to a file are stored once it was not written for this long,
+	// unless the client commits them sooner
+	WriteDelay time.Duration `yaml:"write_delay" json:"write_delay" env:"PEERVAULT_NFS_WRITE_DELAY" default:"5s"`
+
+	// Largest file in bytes clients may write; files are held in memory
+	// while written
+	MaxFileSize int64 `yaml:"max_file_size" json:"max_file_size" env:"PEERVAULT_NFS_MAX_FILE_SIZE" default:"268435456"`
+
+	// Namespaces served
+	Exports []NFSExport `yaml:"exports" json:"exports"`
+}
+
+// NFSExport is a namespace served to NFS clients
+type NFSExport struct {
+	// Path clients mount, e.g. /data
+	Path string `yaml:"path" json:"path"`
+
+	// Key prefix shown, e.g. teams/data/; empty shows every key
+	Namespace string `yaml:"namespace" json:"namespace"`
+
+	// Reject every change
+	ReadOnly bool `yaml:"read_only" json:"read_only"`
+
+	// Addresses and CIDR ranges allowed to mount; empty allows any client
+	Clients []string `yaml:"clients" json:"clients"`
+
+	// How the users of clients are mapped: all (the default) makes every
+	// user the anonymous user, root only root, and none trusts them
+	Squash string `yaml:"squash" json:"squash"`
+
+	// Owner of every file and directory, and the anonymous user; nobody
+	// (65534) when unset
+	UID     *uint32 `yaml:"uid" json:"uid"`
+	GID     *uint32 `yaml:"gid" json:"gid"`
+	AnonUID *uint32 `yaml:"anon_uid" json:"anon_uid"`
+	AnonGID *uint32 `yaml:"anon_gid" json:"anon_gid"`
+
+	// Permission bits of files and directories; 0664 and 0775 when zero
+	FileMode uint32 `yaml:"file_mode" json:"file_mode"`
+	DirMode  uint32 `yaml:"dir_mode" json:"dir_mode"`
+}
+
+// SFTPConfig contains the settings of the SFTP server, which serves the
+// files of the REST API to SSH users over SFTP and scp
+type SFTPConfig struct {
+	// Enable the SFTP server; needs the REST API
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_SFTP_ENABLED" default:"false"`
+
+	// SSH TCP port
+	Port int `yaml:"port" json:"port" env:"PEERVAULT_SFTP_PORT" default:"2022"`
+
+	// File holding the server's host key, created on first start
+	HostKeyFile string `yaml:"host_key_file" json:"host_key_file" env:"PEERVAULT_SFTP_HOST_KEY_FILE" default:"sftp_host_key"`
+
+	// Largest file in bytes users may upload
+	MaxFileSize int64 `yaml:"max_file_size" json:"max_file_size" env:"PEERVAULT_SFTP_MAX_FILE_SIZE" default:"1073741824"`
+
+	// Directory uploads are spooled to until stored; empty is the system's
+	// temporary directory
+	SpoolDir string `yaml:"spool_dir" json:"spool_dir" env:"PEERVAULT_SFTP_SPOOL_DIR"`
+
+	// Users who may sign in
+	Users []SFTPUser `yaml:"users" json:"users"`
+}
+
+// RegistryConfig contains the settings of the container registry, which
+// serves the OCI distribution API under /v2/ of the REST API
+type RegistryConfig struct {
+	// Enable the registry; needs the REST API
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_REGISTRY_ENABLED" default:"false"`
+
+	// Folder of the store holding blobs and repositories
+	Root string `yaml:"root" json:"root" env:"PEERVAULT_REGISTRY_ROOT" default:"registry"`
+
+	// Largest blob in bytes clients may push
+	MaxBlobSize int64 `yaml:"max_blob_size" json:"max_blob_size" env:"PEERVAULT_REGISTRY_MAX_BLOB_SIZE" default:"1073741824"`
+
+	// Largest manifest in bytes
+	MaxManifestSize int64 `yaml:"max_manifest_size" json:"max_manifest_size" env:"PEERVAULT_REGISTRY_MAX_MANIFEST_SIZE" default:"4194304"`
+}
+
+// SFTPUser is an SSH user of the SFTP server
+type SFTPUser struct {
+	// Name the user signs in as
+	Name string `yaml:"name" json:"name"`
+
+	// Tenant owning the files the user uploads
+	Tenant string `yaml:"tenant" json:"tenant"`
+
+	// Key prefix the user is chrooted to; empty is the folder named after
+	// the tenant, "/" every key
+	Namespace string `yaml:"namespace" json:"namespace"`
+
+	// Reject every change
+	ReadOnly bool `yaml:"read_only" json:"read_only"`
+
+	// Public keys in authorized_keys format, e.g. "ssh-ed25519 AAAA... alice"
+	AuthorizedKeys []string `yaml:"authorized_keys" json:"authorized_keys"`
+}
+
+// HTTPSecurityConfig contains the browser protections shared by the REST,
+// GraphQL, WebSocket and SSE APIs
+type HTTPSecurityConfig struct {
+	// Reject state-changing requests from origins not listed by name
+	// unless they carry an Authorization header
+	CSRFProtection bool `yaml:"csrf_protection" json:"csrf_protection" env:"PEERVAULT_HTTP_CSRF_PROTECTION" default:"true"`
+
+	// Strict-Transport-Security max-age sent over TLS; 0 disables HSTS
+	HSTSMaxAge time.Duration `yaml:"hsts_max_age" json:"hsts_max_age" env:"PEERVAULT_HTTP_HSTS_MAX_AGE" default:"8760h"`
+
+	// Content-Security-Policy of API responses; the playground and API
+	// docs pages send their own
+	ContentSecurityPolicy string `yaml:"content_security_policy" json:"content_security_policy" env:"PEERVAULT_HTTP_CSP" default:"default-src 'none'; frame-ancestors 'none'"`
+
+	// How long browsers may cache CORS preflight results
+	PreflightMaxAge time.Duration `yaml:"preflight_max_age" json:"preflight_max_age" env:"PEERVAULT_HTTP_PREFLIGHT_MAX_AGE" default:"10m"`
+}
+
 // PeerConfig contains peer-specific configuration
 type PeerConfig struct {
 	// Maximum number of peers
@@ -267,13 +804,386 @@ type PerformanceConfig struct {
 	// Enable connection multiplexing
 	EnableMultiplexing bool `yaml:"enable_multiplexing" json:"enable_multiplexing" env:"PEERVAULT_ENABLE_MULTIPLEXING" default:"true"`
 
-	// Cache size in MB
+	// Size in MB of the read cache keeping hot files in memory; 0
+	// disables it
 	CacheSize int `yaml:"cache_size" json:"cache_size" env:"PEERVAULT_CACHE_SIZE" default:"100"`
 
-	// Cache TTL
+	// Eviction policy of the read cache: lru, or arc to keep hot files
+	// through scans of cold ones
+	CachePolicy string `yaml:"cache_policy" json:"cache_policy" env:"PEERVAULT_CACHE_POLICY" default:"lru"`
+
+	// How long the read cache keeps a file; 0 keeps it until evicted
 	CacheTTL time.Duration `yaml:"cache_ttl" json:"cache_ttl" env:"PEERVAULT_CACHE_TTL" default:"1h"`
 }
 
+// ChaosConfig injects faults into the P2P layer for resilience testing. It
+// is only honored by test binaries and builds with the chaos build tag.
+type ChaosConfig struct {
+	// Enable fault injection
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_CHAOS_ENABLED" default:"false"`
+
+	// Seed for reproducible faults (0 picks a random seed)
+	Seed uint64 `yaml:"seed" json:"seed" env:"PEERVAULT_CHAOS_SEED" default:"0"`
+
+	// Probability of dropping a received message
+	DropRate float64 `yaml:"drop_rate" json:"drop_rate" env:"PEERVAULT_CHAOS_DROP_RATE" default:"0"`
+
+	// Probability of delaying a received message
+	DelayRate float64 `yaml:"delay_rate" json:"delay_rate" env:"PEERVAULT_CHAOS_DELAY_RATE" default:"0"`
+
+	// Bounds of message delays
+	MinDelay time.Duration `yaml:"min_delay" json:"min_delay" env:"PEERVAULT_CHAOS_MIN_DELAY" default:"0s"`
+	MaxDelay time.Duration `yaml:"max_delay" json:"max_delay" env:"PEERVAULT_CHAOS_MAX_DELAY" default:"0s"`
+
+	// Probability of delivering a received message twice
+	DuplicateRate float64 `yaml:"duplicate_rate" json:"duplicate_rate" env:"PEERVAULT_CHAOS_DUPLICATE_RATE" default:"0"`
+
+	// Probability of corrupting a received message
+	CorruptRate float64 `yaml:"corrupt_rate" json:"corrupt_rate" env:"PEERVAULT_CHAOS_CORRUPT_RATE" default:"0"`
+
+	// Kill a random peer connection this often (0 disables)
+	KillInterval time.Duration `yaml:"kill_interval" json:"kill_interval" env:"PEERVAULT_CHAOS_KILL_INTERVAL" default:"0s"`
+
+	// YAML file scripting a chaos experiment to run at startup
+	Experiment string `yaml:"experiment" json:"experiment" env:"PEERVAULT_CHAOS_EXPERIMENT"`
+}
+
+// DiagnosticsConfig serves pprof profiles, expvar and runtime statistics
+// on a separate port, guarded by a bearer token
+type DiagnosticsConfig struct {
+	// Enable the diagnostics endpoint
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_DIAGNOSTICS_ENABLED" default:"false"`
+
+	// Listen address, on the loopback interface by default
+	Addr string `yaml:"addr" json:"addr" env:"PEERVAULT_DIAGNOSTICS_ADDR" default:"127.0.0.1:6060"`
+
+	// Bearer token required by every request
+	Token string `yaml:"token" json:"token" env:"PEERVAULT_DIAGNOSTICS_TOKEN"`
+}
+
+// ConsensusConfig points the APIs at the Raft group run by peervault-node
+// members, whose locks and leases they then serve
+type ConsensusConfig struct {
+	// URLs of the group's members, e.g. http://10.0.0.1:7000; empty
+	// disables the lease APIs
+	Members []string `yaml:"members" json:"members" env:"PEERVAULT_CONSENSUS_MEMBERS"`
+
+	// Token the members require
+	Token string `yaml:"token" json:"token" env:"PEERVAULT_RAFT_TOKEN"`
+}
+
+// NotificationsConfig is how the node reaches people, e.g. to email
+// scheduled reports
+type NotificationsConfig struct {
+	// SMTP server as host:port; empty disables email
+	SMTPAddr string `yaml:"smtp_addr" json:"smtp_addr" env:"PEERVAULT_SMTP_ADDR"`
+
+	// Sender address of the node's mail
+	SMTPFrom string `yaml:"smtp_from" json:"smtp_from" env:"PEERVAULT_SMTP_FROM"`
+
+	// Credentials for servers requiring authentication
+	SMTPUsername string `yaml:"smtp_username" json:"smtp_username" env:"PEERVAULT_SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" json:"smtp_password" env:"PEERVAULT_SMTP_PASSWORD"`
+}
+
+// ScanConfig checks files as they are stored: their sniffed type and size
+// against the policies, and their content with clamd or an ICAP server
+type ScanConfig struct {
+	// Whether stored files are scanned
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_SCAN_ENABLED" default:"false"`
+
+	// Largest file in bytes; zero disables the limit
+	MaxSize int64 `yaml:"max_size" json:"max_size" env:"PEERVAULT_SCAN_MAX_SIZE"`
+
+	// Content types accepted, all when empty, and refused; entries are MIME
+	// types or prefixes such as image/*
+	AllowTypes []string `yaml:"allow_types" json:"allow_types" env:"PEERVAULT_SCAN_ALLOW_TYPES"`
+	DenyTypes  []string `yaml:"deny_types" json:"deny_types" env:"PEERVAULT_SCAN_DENY_TYPES"`
+
+	// clamd address as host:port or unix:/path/to/clamd.sock
+	Clamd string `yaml:"clamd" json:"clamd" env:"PEERVAULT_SCAN_CLAMD"`
+
+	// ICAP service URL, e.g. icap://scanner:1344/avscan
+	ICAP string `yaml:"icap" json:"icap" env:"PEERVAULT_SCAN_ICAP"`
+
+	// What happens to flagged files: reject, quarantine or tag
+	Action string `yaml:"action" json:"action" env:"PEERVAULT_SCAN_ACTION" default:"reject"`
+
+	// Key prefix of quarantined files
+	QuarantinePrefix string `yaml:"quarantine_prefix" json:"quarantine_prefix" env:"PEERVAULT_SCAN_QUARANTINE_PREFIX" default:"quarantine/"`
+
+	// Store files a scanner failed to check instead of refusing them
+	FailOpen bool `yaml:"fail_open" json:"fail_open" env:"PEERVAULT_SCAN_FAIL_OPEN" default:"false"`
+
+	// Time each scanner has to check a file
+	Timeout time.Duration `yaml:"timeout" json:"timeout" env:"PEERVAULT_SCAN_TIMEOUT" default:"30s"`
+}
+
+// PolicyConfig points the node at CEL rules denying operations, such as
+// stores over a tenant's size limit or replicas outside a region
+type PolicyConfig struct {
+	// YAML or JSON file of rules; empty evaluates no policy
+	File string `yaml:"file" json:"file" env:"PEERVAULT_POLICY_FILE"`
+
+	// File every decision is appended to as a JSON line
+	DecisionLog string `yaml:"decision_log" json:"decision_log" env:"PEERVAULT_POLICY_DECISION_LOG"`
+
+	// Log denials without enforcing them, to try rules on live traffic
+	DryRun bool `yaml:"dry_run" json:"dry_run" env:"PEERVAULT_POLICY_DRY_RUN" default:"false"`
+
+	// Region of this node, which rules read as node.region
+	Region string `yaml:"region" json:"region" env:"PEERVAULT_POLICY_REGION"`
+
+	// Regions of the peers by node ID or address, which rules read as
+	// peer.region
+	Regions map[string]string `yaml:"regions" json:"regions"`
+}
+
+// HooksConfig points the node at WebAssembly hooks run before files are
+// stored, read and deleted and after they are stored
+type HooksConfig struct {
+	// YAML or JSON file listing the hooks; empty runs none
+	File string `yaml:"file" json:"file" env:"PEERVAULT_HOOKS_FILE"`
+}
+
+// ExportsConfig points the node at the exports dumping its metadata,
+// accesses and access rollups for BI tools
+type ExportsConfig struct {
+	// YAML or JSON file listing the exports; empty runs none
+	File string `yaml:"file" json:"file" env:"PEERVAULT_EXPORTS_FILE"`
+}
+
+// MediaConfig records the dimensions of stored images and videos and
+// serves thumbnails of them
+type MediaConfig struct {
+	// Whether media is probed and thumbnails are served
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_MEDIA_ENABLED" default:"false"`
+
+	// Longest edges in pixels thumbnails are made at; 64, 256 and 1024
+	// when empty
+	Sizes []int `yaml:"sizes" json:"sizes"`
+
+	// Render every size when a file is stored instead of on first request
+	OnIngest bool `yaml:"on_ingest" json:"on_ingest" env:"PEERVAULT_MEDIA_ON_INGEST" default:"false"`
+
+	// ffmpeg binary grabbing poster frames of videos; videos get no
+	// previews when empty
+	FFmpeg string `yaml:"ffmpeg" json:"ffmpeg" env:"PEERVAULT_MEDIA_FFMPEG"`
+
+	// ffprobe binary, by default next to ffmpeg
+	FFprobe string `yaml:"ffprobe" json:"ffprobe" env:"PEERVAULT_MEDIA_FFPROBE"`
+
+	// JPEG quality of thumbnails
+	Quality int `yaml:"quality" json:"quality" env:"PEERVAULT_MEDIA_QUALITY" default:"80"`
+
+	// Time each run of ffmpeg or ffprobe has
+	Timeout time.Duration `yaml:"timeout" json:"timeout" env:"PEERVAULT_MEDIA_TIMEOUT" default:"30s"`
+
+	// Renditions videos and audio are streamed in over HLS; 360p and 720p
+	// when empty
+	Profiles []MediaProfile `yaml:"profiles" json:"profiles"`
+
+	// Length of stream segments in seconds
+	SegmentSeconds int `yaml:"segment_seconds" json:"segment_seconds" env:"PEERVAULT_MEDIA_SEGMENT_SECONDS" default:"6"`
+}
+
+// JSONConfig binds JSON Schemas to the key prefixes of JSON documents
+type JSONConfig struct {
+	// YAML file of prefix and schema pairs; empty accepts any JSON
+	Schemas string `yaml:"schemas" json:"schemas" env:"PEERVAULT_JSON_SCHEMAS"`
+}
+
+// LogsConfig contains the configuration of append-only logs
+type LogsConfig struct {
+	// Whether the REST API serves logs; the gRPC API always does
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_LOGS_ENABLED" default:"false"`
+
+	// Size at which a segment is sealed and a new one started
+	SegmentBytes int64 `yaml:"segment_bytes" json:"segment_bytes" env:"PEERVAULT_LOGS_SEGMENT_BYTES" default:"262144"`
+
+	// Age after which records are dropped; 0 keeps them forever
+	MaxAge time.Duration `yaml:"max_age" json:"max_age" env:"PEERVAULT_LOGS_MAX_AGE" default:"0"`
+
+	// Size above which each log drops its oldest records; 0 does not limit
+	// the size
+	MaxBytes int64 `yaml:"max_bytes" json:"max_bytes" env:"PEERVAULT_LOGS_MAX_BYTES" default:"0"`
+}
+
+// TopicsConfig contains the configuration of durable pub/sub topics, whose
+// messages the MQTT, WebSocket and SSE APIs keep in logs
+type TopicsConfig struct {
+	// Whether the node keeps durable topics
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_TOPICS_ENABLED" default:"false"`
+
+	// Prefixes of the topics that are durable; "" makes every topic durable
+	Prefixes []string `yaml:"prefixes" json:"prefixes" env:"PEERVAULT_TOPICS_PREFIXES"`
+
+	// Size at which a segment of a topic's log is sealed
+	SegmentBytes int64 `yaml:"segment_bytes" json:"segment_bytes" env:"PEERVAULT_TOPICS_SEGMENT_BYTES" default:"262144"`
+
+	// Age after which messages are dropped; 0 keeps them forever
+	MaxAge time.Duration `yaml:"max_age" json:"max_age" env:"PEERVAULT_TOPICS_MAX_AGE" default:"168h"`
+
+	// Size above which each topic drops its oldest messages; 0 does not
+	// limit the size
+	MaxBytes int64 `yaml:"max_bytes" json:"max_bytes" env:"PEERVAULT_TOPICS_MAX_BYTES" default:"0"`
+
+	// How often acknowledged offsets of consumer groups are stored
+	CommitInterval time.Duration `yaml:"commit_interval" json:"commit_interval" env:"PEERVAULT_TOPICS_COMMIT_INTERVAL" default:"5s"`
+}
+
+// EdgeConfig makes the node an edge cache of an upstream cluster: files
+// the node's cluster does not have are pulled from the upstream, kept and
+// served locally
+type EdgeConfig struct {
+	// Base URL of the upstream cluster's REST API; empty disables pulling
+	Upstream string `yaml:"upstream" json:"upstream" env:"PEERVAULT_EDGE_UPSTREAM"`
+
+	// Bearer token presented to the upstream cluster
+	Token string `yaml:"token" json:"-" env:"PEERVAULT_EDGE_TOKEN"`
+
+	// How long a pulled file is served before the upstream is asked
+	// whether it changed. Files no one asks for during another TTL are
+	// deleted.
+	TTL time.Duration `yaml:"ttl" json:"ttl" env:"PEERVAULT_EDGE_TTL" default:"5m"`
+
+	// Prefixes of the keys pulled from the upstream; all keys when empty
+	Prefixes []string `yaml:"prefixes" json:"prefixes" env:"PEERVAULT_EDGE_PREFIXES"`
+}
+
+// GeoConfig routes clients of a multi-region cluster to the node nearest
+// to them. Nodes tell their peers their region, location and public URL;
+// the API gateway redirects clients nearer to another node, and GeoDNS
+// answers with the nearest node's address.
+type GeoConfig struct {
+	// Whether the API gateway redirects clients to the nearest node
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_GEO_ENABLED" default:"false"`
+
+	// Region of this node; empty uses policy.region
+	Region string `yaml:"region" json:"region" env:"PEERVAULT_GEO_REGION"`
+
+	// Location of this node as "latitude,longitude"; nodes without one are
+	// chosen by region and latency only
+	Location string `yaml:"location" json:"location" env:"PEERVAULT_GEO_LOCATION"`
+
+	// Base URL clients reach this node at, such as https://eu.example.com;
+	// clients are not sent to nodes without one
+	PublicURL string `yaml:"public_url" json:"public_url" env:"PEERVAULT_GEO_PUBLIC_URL"`
+
+	// CSV GeoIP database of network,country,region,latitude,longitude
+	// rows; empty locates only the clients of Networks
+	Database string `yaml:"database" json:"database" env:"PEERVAULT_GEO_DATABASE"`
+
+	// Regions of client networks by CIDR prefix, checked before the
+	// database
+	Networks map[string]string `yaml:"networks" json:"networks"`
+
+	// Paths whose GET and HEAD requests are redirected
+	RedirectPaths []string `yaml:"redirect_paths" json:"redirect_paths" env:"PEERVAULT_GEO_REDIRECT_PATHS"`
+
+	// Name GeoDNS answers for; empty disables GeoDNS
+	DNSName string `yaml:"dns_name" json:"dns_name" env:"PEERVAULT_GEO_DNS_NAME"`
+
+	// UDP port of GeoDNS
+	DNSPort int `yaml:"dns_port" json:"dns_port" env:"PEERVAULT_GEO_DNS_PORT" default:"5353"`
+
+	// TTL of GeoDNS answers
+	DNSTTL time.Duration `yaml:"dns_ttl" json:"dns_ttl" env:"PEERVAULT_GEO_DNS_TTL" default:"30s"`
+}
+
+// DevicesConfig runs a CA issuing client certificates to IoT devices.
+// Devices registered through the API enroll and renew with EST over HTTP
+// (RFC 7030) on the REST API and over CoAP (RFC 9148), proving the secret
+// returned when they were registered.
+type DevicesConfig struct {
+	// Run the device CA
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_DEVICES_ENABLED" default:"false"`
+
+	// Directory of the CA certificate and key and the registered devices;
+	// empty uses devices under the storage root. A CA is created there on
+	// first use unless ca.pem and ca-key.pem are provided.
+	Dir string `yaml:"dir" json:"dir" env:"PEERVAULT_DEVICES_DIR"`
+
+	// How long device certificates are valid
+	Validity time.Duration `yaml:"validity" json:"validity" env:"PEERVAULT_DEVICES_VALIDITY" default:"2160h"`
+}
+
+// FirmwareConfig distributes firmware images to IoT devices in staged
+// rollouts. Images are uploaded through the REST API, signed with the
+// node's firmware key and served over HTTPS, CoAP and MQTT. With the
+// device CA, only the devices it registered get updates.
+type FirmwareConfig struct {
+	// Distribute firmware
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_FIRMWARE_ENABLED" default:"false"`
+
+	// Directory of the signing key and of the images, rollouts and device
+	// statuses; empty uses firmware under the storage root. The images
+	// themselves are stored as files of the node.
+	Dir string `yaml:"dir" json:"dir" env:"PEERVAULT_FIRMWARE_DIR"`
+}
+
+// TasksConfig lets peers run the node's task handlers, the built-in
+// hash-check, compress and decompress and the registered processing
+// plugins, streaming their input to the node and its output back
+type TasksConfig struct {
+	// Run task handlers and call those of the peers
+	Enabled bool `yaml:"enabled" json:"enabled" env:"PEERVAULT_TASKS_ENABLED" default:"false"`
+
+	// Node IDs allowed to run each handler by its name, "*" allowing
+	// every peer; handlers it does not list only run for this node
+	Allow map[string][]string `yaml:"allow" json:"allow"`
+
+	// How many calls of peers run at once
+	MaxConcurrent int `yaml:"max_concurrent" json:"max_concurrent" env:"PEERVAULT_TASKS_MAX_CONCURRENT" default:"16"`
+
+	// How long a call of a peer runs before it is canceled
+	Timeout time.Duration `yaml:"timeout" json:"timeout" env:"PEERVAULT_TASKS_TIMEOUT" default:"10m"`
+}
+
+// PluginsConfig launches plugins built with pkg/plugin. Each capability a
+// plugin provides is put to use: its storage as the "plugin" backup
+// target, its auth provider for REST API requests without the API
+// token, its scanner alongside clamd and ICAP when scanning is enabled,
+// and its event sink with the changes to files.
+type PluginsConfig struct {
+	// Plugins launched on start
+	External []PluginConfig `yaml:"external" json:"external"`
+}
+
+// PluginConfig launches a plugin executable
+type PluginConfig struct {
+	// Name the plugin is referred to by, e.g. in backup targets: letters,
+	// digits, - and _
+	Name string `yaml:"name" json:"name"`
+
+	// Executable and the arguments it is started with
+	Path string   `yaml:"path" json:"path"`
+	Args []string `yaml:"args" json:"args"`
+
+	// Environment variables as KEY=value, added to the server's
+	Env []string `yaml:"env" json:"-"`
+
+	// How long a call of the plugin may take; 30s when zero
+	Timeout time.Duration `yaml:"timeout" json:"timeout"`
+}
+
+// MediaProfile is a rendition streams are transcoded to
+type MediaProfile struct {
+	// Name in stream URLs: letters, digits, - and _
+	Name string `yaml:"name" json:"name"`
+
+	// Height of the video in pixels; the source's when zero
+	Height int `yaml:"height" json:"height"`
+
+	// Bitrates such as 2800k of the H.264 video and AAC audio
+	VideoBitrate string `yaml:"video_bitrate" json:"video_bitrate"`
+	AudioBitrate string `yaml:"audio_bitrate" json:"audio_bitrate"`
+
+	// ffmpeg arguments replacing the encoder settings, e.g. for a hardware
+	// encoder
+	Args []string `yaml:"args" json:"args"`
+}
+
 // Manager handles configuration loading, validation, and hot reloading
 type Manager struct {
 	config     *Config
@@ -297,21 +1207,36 @@ func DefaultConfig() *Config {
 			ShutdownTimeout: 30 * time.Second,
 		},
 		Storage: StorageConfig{
-			Root:             "./storage",
-			MaxFileSize:      1073741824, // 1GB
-			Compression:      false,
-			CompressionLevel: 6,
-			Deduplication:    false,
-			CleanupInterval:  1 * time.Hour,
-			RetentionPeriod:  24 * time.Hour,
+			Root:              "./storage",
+			MaxFileSize:       1073741824, // 1GB
+			Compression:       false,
+			CompressionLevel:  6,
+			Deduplication:     false,
+			CleanupInterval:   1 * time.Hour,
+			RetentionPeriod:   24 * time.Hour,
+			ReplicationFactor: 3,
+			HighWatermark:     0.9,
+			Hash:              "sha1",
 		},
 		Network: NetworkConfig{
-			BootstrapNodes:    []string{},
-			ConnectionTimeout: 30 * time.Second,
-			ReadTimeout:       60 * time.Second,
-			WriteTimeout:      60 * time.Second,
-			KeepAliveInterval: 30 * time.Second,
-			MaxMessageSize:    1048576, // 1MB
+			BootstrapNodes:       []string{},
+			ConnectionTimeout:    30 * time.Second,
+			ReadTimeout:          60 * time.Second,
+			WriteTimeout:         60 * time.Second,
+			KeepAliveInterval:    30 * time.Second,
+			MaxMessageSize:       1048576, // 1MB
+			LatencyProbeInterval: 10 * time.Second,
+			SeedInterval:         time.Minute,
+			Swarm: SwarmConfig{
+				MinSize: 8 << 20,
+			},
+			MDNS: MDNSConfig{
+				Service:  "_peervault._tcp",
+				Interval: 30 * time.Second,
+			},
+			Join: JoinConfig{
+				TokenTTL: 24 * time.Hour,
+			},
 		},
 		Security: SecurityConfig{
 			ClusterKey:          "",
@@ -323,6 +1248,12 @@ func DefaultConfig() *Config {
 			EncryptionAtRest:    true,
 			EncryptionInTransit: true,
 			AllowDemoToken:      true,
+			ACME: ACMEConfig{
+				CacheDir:    "acme-cache",
+				HTTPAddr:    ":80",
+				RenewBefore: 30 * 24 * time.Hour,
+			},
+			ReencryptionRate: 8 << 20,
 		},
 		Logging: LoggingConfig{
 			Level:         "info",
@@ -358,8 +1289,60 @@ func DefaultConfig() *Config {
 				Port:                 8082,
 				AuthToken:            "demo-token",
 				EnableReflection:     true,
+				LogRequests:          true,
 				MaxConcurrentStreams: 100,
 			},
+			WebSocket: WebSocketConfig{
+				Enabled:        false,
+				Port:           8083,
+				AllowedOrigins: []string{"*"},
+			},
+			SSE: SSEConfig{
+				Enabled:        false,
+				Port:           8084,
+				AllowedOrigins: []string{"*"},
+				HistorySize:    1000,
+			},
+			MQTT: MQTTConfig{
+				Enabled:         false,
+				Port:            1883,
+				EnableWebSocket: false,
+				WebSocketPort:   8085,
+			},
+			CoAP: CoAPConfig{
+				Enabled: false,
+				Port:    5683,
+			},
+			Kafka: KafkaConfig{
+				Port:   9092,
+				Prefix: "durable/",
+			},
+			NFS: NFSConfig{
+				Port:         2049,
+				AttrCacheTTL: 3 * time.Second,
+				WriteDelay:   5 * time.Second,
+				MaxFileSize:  256 << 20,
+			},
+			SFTP: SFTPConfig{
+				Port:        2022,
+				HostKeyFile: "sftp_host_key",
+				MaxFileSize: 1 << 30,
+			},
+			Registry: RegistryConfig{
+				Root:            "registry",
+				MaxBlobSize:     1 << 30,
+				MaxManifestSize: 4 << 20,
+			},
+			HTTPSecurity: HTTPSecurityConfig{
+				CSRFProtection:        true,
+				HSTSMaxAge:            365 * 24 * time.Hour,
+				ContentSecurityPolicy: "default-src 'none'; frame-ancestors 'none'",
+				PreflightMaxAge:       10 * time.Minute,
+			},
+			Gateway: GatewayConfig{
+				Port:            8000,
+				RateLimitPerMin: 600,
+			},
 		},
 		Peer: PeerConfig{
 			MaxPeers:             100,
@@ -374,8 +1357,39 @@ func DefaultConfig() *Config {
 			ConnectionPoolSize:          10,
 			EnableMultiplexing:          true,
 			CacheSize:                   100,
+			CachePolicy:                 "lru",
 			CacheTTL:                    1 * time.Hour,
 		},
+		Scan: ScanConfig{
+			Action:           "reject",
+			QuarantinePrefix: "quarantine/",
+			Timeout:          30 * time.Second,
+		},
+		Media: MediaConfig{
+			Quality:        80,
+			Timeout:        30 * time.Second,
+			SegmentSeconds: 6,
+		},
+		Logs: LogsConfig{
+			SegmentBytes: 256 << 10,
+		},
+		Topics: TopicsConfig{
+			Prefixes:       []string{"durable/"},
+			SegmentBytes:   256 << 10,
+			MaxAge:         7 * 24 * time.Hour,
+			CommitInterval: 5 * time.Second,
+		},
+		Edge: EdgeConfig{
+			TTL: 5 * time.Minute,
+		},
+		Geo: GeoConfig{
+			RedirectPaths: []string{"/api/v1/files/", "/public/"},
+			DNSPort:       5353,
+			DNSTTL:        30 * time.Second,
+		},
+		Devices: DevicesConfig{
+			Validity: 90 * 24 * time.Hour,
+		},
 	}
 }
 
@@ -3,9 +3,19 @@ package config
 import (
 	"fmt"
 	"net"
+	"net/mail"
+	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/Skpow1234/Peervault/internal/geo"
 )
 
 // ValidationError represents a configuration validation error
@@ -121,6 +131,96 @@ func (v *DefaultValidator) Validate(config *Config) error {
 		result.AddError(err.Field, err.Message)
 	}
 
+	// Validate chaos configuration
+	if err := v.validateChaos(config.Chaos); err != nil {
+		result.AddError(err.Field, err.Message)
+	}
+
+	if err := v.validateDiagnostics(config.Diagnostics); err != nil {
+		result.AddError(err.Field, err.Message)
+	}
+
+	if err := v.validateConsensus(config.Consensus); err != nil {
+		result.AddError(err.Field, err.Message)
+	}
+
+	if err := v.validateNotifications(config.Notifications); err != nil {
+		result.AddError(err.Field, err.Message)
+	}
+
+	if err := v.validateScan(config.Scan); err != nil {
+		result.AddError(err.Field, err.Message)
+	}
+
+	if err := v.validatePolicy(config.Policy); err != nil {
+		result.AddError(err.Field, err.Message)
+	}
+
+	if err := v.validateMedia(config.Media); err != nil {
+		result.AddError(err.Field, err.Message)
+	}
+
+	if err := v.validateJSON(config.JSON); err != nil {
+		result.AddError(err.Field, err.Message)
+	}
+
+	if err := v.validateLogs(config.Logs); err != nil {
+		result.AddError(err.Field, err.Message)
+	}
+
+	if err := v.validateTopics(config.Topics); err != nil {
+		result.AddError(err.Field, err.Message)
+	}
+
+	if err := v.validateEdge(config.Edge); err != nil {
+		result.AddError(err.Field, err.Message)
+	}
+
+	if err := v.validateGeo(config.Geo); err != nil {
+		result.AddError(err.Field, err.Message)
+	}
+
+	if err := v.validateDevices(config.Devices); err != nil {
+		result.AddError(err.Field, err.Message)
+	}
+
+	if err := v.validatePlugins(config.Plugins); err != nil {
+		result.AddError(err.Field, err.Message)
+	}
+
+	if err := v.validateHooks(config.Hooks); err != nil {
+		result.AddError(err.Field, err.Message)
+	}
+
+	if err := v.validateExports(config.Exports); err != nil {
+		result.AddError(err.Field, err.Message)
+	}
+
+	// MQTT clients present certificates of the device CA over TLS
+	if config.API.MQTT.Enabled && config.API.MQTT.DeviceCertificates {
+		if !config.Devices.Enabled {
+			result.AddError("api.mqtt.device_certificates", "device certificates need the device CA to be enabled")
+		} else if !config.Security.TLS {
+			result.AddError("api.mqtt.device_certificates", "device certificates need security.tls for the broker's own certificate")
+		}
+	}
+	if config.API.MQTT.Enabled && config.API.MQTT.ACLFile != "" {
+		if _, err := os.Stat(config.API.MQTT.ACLFile); err != nil {
+			result.AddError("api.mqtt.acl_file", "MQTT topic ACL file cannot be read")
+		}
+	}
+
+	// The Kafka listener serves durable topics
+	if config.API.Kafka.Enabled {
+		if !config.Topics.Enabled {
+			result.AddError("api.kafka.enabled", "the Kafka listener needs durable topics to be enabled")
+		} else if !slices.ContainsFunc(config.Topics.Prefixes, func(prefix string) bool {
+			return strings.HasPrefix(config.API.Kafka.Prefix, prefix)
+		}) {
+			result.AddError("api.kafka.prefix", "prefix must start with one of the durable topic prefixes")
+		}
+	}
+
 	// Return combined errors
 	if result.HasErrors() {
 		return result
@@ -185,9 +285,34 @@ func (v *DefaultValidator) validateStorage(config StorageConfig) *ValidationErro
 		return &ValidationError{Field: "storage.retention_period", Message: "retention period must be positive"}
 	}
 
+	// Validate replication factor; zero uses the default
+	if config.ReplicationFactor < 0 {
+		return &ValidationError{Field: "storage.replication_factor", Message: "replication factor cannot be negative"}
+	}
+
+	// Validate capacity; zero uses the default
+	if config.Capacity < 0 {
+		return &ValidationError{Field: "storage.capacity", Message: "capacity cannot be negative"}
+	}
+
+	// Validate high watermark; zero uses the default
+	if config.HighWatermark < 0 || config.HighWatermark > 1 {
+		return &ValidationError{Field: "storage.high_watermark", Message: "high watermark must be between 0 and 1"}
+	}
+
+	// Validate hash; empty keeps the default
+	switch strings.ToLower(config.Hash) {
+	case "", "sha1", "sha256", "blake3":
+	default:
+		return &ValidationError{Field: "storage.hash", Message: "hash must be one of sha1, sha256, blake3"}
+	}
+
 	return nil
 }
 
+// mdnsServiceName matches DNS-SD service types such as _peervault._tcp
+var mdnsServiceName = regexp.MustCompile(`^_[A-Za-z0-9-]{1,15}\._(tcp|udp)$`)
+
 // validateNetwork validates network configuration
 func (v *DefaultValidator) validateNetwork(config NetworkConfig) *ValidationError {
 	// Validate bootstrap nodes
@@ -225,6 +350,70 @@ func (v *DefaultValidator) validateNetwork(config NetworkConfig) *ValidationErro
 		return &ValidationError{Field: "network.max_message_size", Message: "max message size must be positive"}
 	}
 
+	// Validate latency probe interval; zero uses the default
+	if config.LatencyProbeInterval < 0 {
+		return &ValidationError{Field: "network.latency_probe_interval", Message: "latency probe interval cannot be negative"}
+	}
+
+	// Validate peer ACL networks
+	for field, cidrs := range map[string][]string{"allow_cidrs": config.ACL.AllowCIDRs, "deny_cidrs": config.ACL.DenyCIDRs} {
+		for i, cidr := range cidrs {
+			if _, err := netip.ParsePrefix(cidr); err == nil {
+				continue
+			}
+			if _, err := netip.ParseAddr(cidr); err != nil {
+				return &ValidationError{Field: fmt.Sprintf("network.acl.%s[%d]", field, i), Message: fmt.Sprintf("invalid network %q", cidr)}
+			}
+		}
+	}
+
+	// Validate connection limits; negative limits are off, but bursts
+	// only size them
+	if config.Limits.ConnectionBurst < 0 {
+		return &ValidationError{Field: "network.limits.connection_burst", Message: "connection burst cannot be negative"}
+	}
+	if config.Limits.MessageBurst < 0 {
+		return &ValidationError{Field: "network.limits.message_burst", Message: "message burst cannot be negative"}
+	}
+
+	// Validate swarm downloads; negative sizes turn them off
+	if config.Swarm.PeerRate < 0 {
+		return &ValidationError{Field: "network.swarm.peer_rate", Message: "peer rate cannot be negative"}
+	}
+
+	// Validate seeds and the rendezvous service
+	if config.SeedInterval < 0 {
+		return &ValidationError{Field: "network.seed_interval", Message: "seed interval cannot be negative"}
+	}
+	for i, seed := range config.Seeds {
+		if strings.TrimSpace(seed) == "" {
+			return &ValidationError{Field: fmt.Sprintf("network.seeds[%d]", i), Message: "seed cannot be empty"}
+		}
+	}
+	if config.Rendezvous.URL != "" {
+		if u, err := url.Parse(config.Rendezvous.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &ValidationError{Field: "network.rendezvous.url", Message: fmt.Sprintf("invalid URL %q", config.Rendezvous.URL)}
+		}
+	}
+
+	// Validate local discovery; zero intervals use the default
+	if config.MDNS.Interval < 0 {
+		return &ValidationError{Field: "network.mdns.interval", Message: "mDNS interval cannot be negative"}
+	}
+	if service := config.MDNS.Service; service != "" && !mdnsServiceName.MatchString(service) {
+		return &ValidationError{Field: "network.mdns.service", Message: fmt.Sprintf("invalid DNS-SD service type %q, expected _name._tcp", service)}
+	}
+
+	// Validate join tokens; a zero TTL uses the default
+	if config.Join.TokenTTL < 0 {
+		return &ValidationError{Field: "network.join.token_ttl", Message: "join token TTL cannot be negative"}
+	}
+	if addr := config.Join.AdvertiseAddr; addr != "" {
+		if _, port, err := net.SplitHostPort(addr); err != nil || port == "" {
+			return &ValidationError{Field: "network.join.advertise_addr", Message: fmt.Sprintf("invalid address %q, expected host:port", addr)}
+		}
+	}
+
 	return nil
 }
 
@@ -236,7 +425,17 @@ func (v *DefaultValidator) validateSecurity(config SecurityConfig) *ValidationEr
 	}
 
 	// Validate TLS configuration
-	if config.TLS {
+	if config.TLS && config.ACME.Enabled {
+		if len(config.ACME.Domains) == 0 {
+			return &ValidationError{Field: "security.acme.domains", Message: "at least one domain is required when ACME is enabled"}
+		}
+		if config.ACME.CacheDir == "" {
+			return &ValidationError{Field: "security.acme.cache_dir", Message: "ACME cache directory is required when ACME is enabled"}
+		}
+		if config.ACME.RenewBefore < 0 {
+			return &ValidationError{Field: "security.acme.renew_before", Message: "ACME renewal time cannot be negative"}
+		}
+	} else if config.TLS {
 		if config.TLSCertFile == "" {
 			return &ValidationError{Field: "security.tls_cert_file", Message: "TLS certificate file is required when TLS is enabled"}
 		}
@@ -338,9 +537,68 @@ func (v *DefaultValidator) validateAPI(config APIConfig) *ValidationError {
 		return err
 	}
 
+	// Validate the remaining protocol ports
+	if config.WebSocket.Enabled && !validPort(config.WebSocket.Port) {
+		return &ValidationError{Field: "api.websocket.port", Message: "port must be between 1 and 65535"}
+	}
+	if config.SSE.Enabled && !validPort(config.SSE.Port) {
+		return &ValidationError{Field: "api.sse.port", Message: "port must be between 1 and 65535"}
+	}
+	if config.SSE.HistorySize < 0 {
+		return &ValidationError{Field: "api.sse.history_size", Message: "history size cannot be negative"}
+	}
+	if config.MQTT.Enabled {
+		if !validPort(config.MQTT.Port) {
+			return &ValidationError{Field: "api.mqtt.port", Message: "port must be between 1 and 65535"}
+		}
+		if config.MQTT.EnableWebSocket && !validPort(config.MQTT.WebSocketPort) {
+			return &ValidationError{Field: "api.mqtt.websocket_port", Message: "port must be between 1 and 65535"}
+		}
+	}
+	if config.CoAP.Enabled && !validPort(config.CoAP.Port) {
+		return &ValidationError{Field: "api.coap.port", Message: "port must be between 1 and 65535"}
+	}
+	if config.Kafka.Enabled && !validPort(config.Kafka.Port) {
+		return &ValidationError{Field: "api.kafka.port", Message: "port must be between 1 and 65535"}
+	}
+	if err := v.validateNFS(config.NFS, config.REST.Enabled); err != nil {
+		return err
+	}
+	if err := v.validateSFTP(config.SFTP, config.REST.Enabled); err != nil {
+		return err
+	}
+	if err := v.validateRegistry(config.Registry, config.REST.Enabled); err != nil {
+		return err
+	}
+	if config.Gateway.Enabled {
+		if !validPort(config.Gateway.Port) {
+			return &ValidationError{Field: "api.gateway.port", Message: "port must be between 1 and 65535"}
+		}
+		if config.Gateway.RateLimitPerMin < 0 {
+			return &ValidationError{Field: "api.gateway.rate_limit_per_min", Message: "rate limit cannot be negative"}
+		}
+		for name := range config.Gateway.Hosts {
+			switch name {
+			case "rest", "graphql", "websocket", "sse", "federation":
+			default:
+				return &ValidationError{Field: "api.gateway.hosts", Message: "unknown API " + name}
+			}
+		}
+	}
+	if config.HTTPSecurity.HSTSMaxAge < 0 {
+		return &ValidationError{Field: "api.http_security.hsts_max_age", Message: "HSTS max age cannot be negative"}
+	}
+	if config.HTTPSecurity.PreflightMaxAge < 0 {
+		return &ValidationError{Field: "api.http_security.preflight_max_age", Message: "preflight max age cannot be negative"}
+	}
+
 	return nil
 }
 
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
 // validateREST validates REST API configuration
 func (v *DefaultValidator) validateREST(config RESTConfig) *ValidationError {
 	if !config.Enabled {
@@ -410,6 +668,123 @@ func (v *DefaultValidator) validateGRPC(config GRPCConfig) *ValidationError {
 		return &ValidationError{Field: "api.grpc.max_concurrent_streams", Message: "max concurrent streams must be positive"}
 	}
 
+	// Validate rate limit
+	if config.RateLimit < 0 || config.RateLimitBurst < 0 {
+		return &ValidationError{Field: "api.grpc.rate_limit", Message: "rate limit and burst cannot be negative"}
+	}
+
+	return nil
+}
+
+// validateNFS validates the NFS gateway, which serves the files of the
+// REST API
+func (v *DefaultValidator) validateNFS(config NFSConfig, restEnabled bool) *ValidationError {
+	if !config.Enabled {
+		return nil
+	}
+	if !restEnabled {
+		return &ValidationError{Field: "api.nfs.enabled", Message: "the NFS gateway needs the REST API to be enabled"}
+	}
+	if !validPort(config.Port) {
+		return &ValidationError{Field: "api.nfs.port", Message: "port must be between 1 and 65535"}
+	}
+	if config.AttrCacheTTL < 0 || config.WriteDelay < 0 {
+		return &ValidationError{Field: "api.nfs.attr_cache_ttl", Message: "attribute cache TTL and write delay cannot be negative"}
+	}
+	if config.MaxFileSize <= 0 {
+		return &ValidationError{Field: "api.nfs.max_file_size", Message: "max file size must be positive"}
+	}
+	if len(config.Exports) == 0 {
+		return &ValidationError{Field: "api.nfs.exports", Message: "at least one export is required"}
+	}
+
+	paths := make(map[string]bool)
+	for _, e := range config.Exports {
+		if !strings.HasPrefix(e.Path, "/") || filepath.ToSlash(filepath.Clean(e.Path)) != e.Path {
+			return &ValidationError{Field: "api.nfs.exports", Message: fmt.Sprintf("export path %q must be an absolute, clean path", e.Path)}
+		}
+		if paths[e.Path] {
+			return &ValidationError{Field: "api.nfs.exports", Message: fmt.Sprintf("export %s is listed twice", e.Path)}
+		}
+		paths[e.Path] = true
+		switch e.Squash {
+		case "", "all", "root", "none":
+		default:
+			return &ValidationError{Field: "api.nfs.exports", Message: fmt.Sprintf("export %s: squash must be all, root or none", e.Path)}
+		}
+		for _, c := range e.Clients {
+			if _, _, err := net.ParseCIDR(c); err != nil && net.ParseIP(c) == nil {
+				return &ValidationError{Field: "api.nfs.exports", Message: fmt.Sprintf("export %s: invalid client %q", e.Path, c)}
+			}
+		}
+		if e.FileMode > 0o777 || e.DirMode > 0o777 {
+			return &ValidationError{Field: "api.nfs.exports", Message: fmt.Sprintf("export %s: modes are permission bits up to 0777", e.Path)}
+		}
+	}
+	return nil
+}
+
+// validateSFTP validates the SFTP server, which serves the files of the
+// REST API
+func (v *DefaultValidator) validateSFTP(config SFTPConfig, restEnabled bool) *ValidationError {
+	if !config.Enabled {
+		return nil
+	}
+	if !restEnabled {
+		return &ValidationError{Field: "api.sftp.enabled", Message: "the SFTP server needs the REST API to be enabled"}
+	}
+	if !validPort(config.Port) {
+		return &ValidationError{Field: "api.sftp.port", Message: "port must be between 1 and 65535"}
+	}
+	if config.HostKeyFile == "" {
+		return &ValidationError{Field: "api.sftp.host_key_file", Message: "host key file is required"}
+	}
+	if config.MaxFileSize <= 0 {
+		return &ValidationError{Field: "api.sftp.max_file_size", Message: "max file size must be positive"}
+	}
+	if len(config.Users) == 0 {
+		return &ValidationError{Field: "api.sftp.users", Message: "at least one user is required"}
+	}
+
+	names := make(map[string]bool)
+	for _, u := range config.Users {
+		switch {
+		case u.Name == "":
+			return &ValidationError{Field: "api.sftp.users", Message: "every user needs a name"}
+		case names[u.Name]:
+			return &ValidationError{Field: "api.sftp.users", Message: fmt.Sprintf("user %s is listed twice", u.Name)}
+		case u.Tenant == "":
+			return &ValidationError{Field: "api.sftp.users", Message: fmt.Sprintf("user %s needs a tenant", u.Name)}
+		case len(u.AuthorizedKeys) == 0:
+			return &ValidationError{Field: "api.sftp.users", Message: fmt.Sprintf("user %s needs at least one authorized key", u.Name)}
+		}
+		names[u.Name] = true
+		for _, key := range u.AuthorizedKeys {
+			if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err != nil {
+				return &ValidationError{Field: "api.sftp.users", Message: fmt.Sprintf("user %s: invalid authorized key: %v", u.Name, err)}
+			}
+		}
+	}
+	return nil
+}
+
+// validateRegistry validates the container registry of the REST API
+func (v *DefaultValidator) validateRegistry(config RegistryConfig, restEnabled bool) *ValidationError {
+	if !config.Enabled {
+		return nil
+	}
+	if !restEnabled {
+		return &ValidationError{Field: "api.registry.enabled", Message: "the container registry needs the REST API to be enabled"}
+	}
+	if strings.Trim(config.Root, "/") == "" {
+		return &ValidationError{Field: "api.registry.root", Message: "root folder is required"}
+	}
+	if config.MaxBlobSize <= 0 {
+		return &ValidationError{Field: "api.registry.max_blob_size", Message: "max blob size must be positive"}
+	}
+	if config.MaxManifestSize <= 0 {
+		return &ValidationError{Field: "api.registry.max_manifest_size", Message: "max manifest size must be positive"}
+	}
 	return nil
 }
 
@@ -465,6 +840,11 @@ func (v *DefaultValidator) validatePerformance(config PerformanceConfig) *Valida
 		return &ValidationError{Field: "performance.cache_size", Message: "cache size cannot be negative"}
 	}
 
+	// Validate cache policy
+	if config.CachePolicy != "" && config.CachePolicy != "lru" && config.CachePolicy != "arc" {
+		return &ValidationError{Field: "performance.cache_policy", Message: "cache policy must be lru or arc"}
+	}
+
 	// Validate cache TTL
 	if config.CacheTTL < 0 {
 		return &ValidationError{Field: "performance.cache_ttl", Message: "cache TTL cannot be negative"}
@@ -473,6 +853,342 @@ func (v *DefaultValidator) validatePerformance(config PerformanceConfig) *Valida
 	return nil
 }
 
+// validateChaos validates chaos testing configuration
+func (v *DefaultValidator) validateChaos(config ChaosConfig) *ValidationError {
+	rates := []struct {
+		field string
+		value float64
+	}{
+		{"chaos.drop_rate", config.DropRate},
+		{"chaos.delay_rate", config.DelayRate},
+		{"chaos.duplicate_rate", config.DuplicateRate},
+		{"chaos.corrupt_rate", config.CorruptRate},
+	}
+	for _, rate := range rates {
+		if rate.value < 0 || rate.value > 1 {
+			return &ValidationError{Field: rate.field, Message: "rate must be between 0 and 1"}
+		}
+	}
+
+	// Validate delay bounds
+	if config.MinDelay < 0 || config.MaxDelay < config.MinDelay {
+		return &ValidationError{Field: "chaos.max_delay", Message: "max delay must be at least min delay"}
+	}
+
+	if config.KillInterval < 0 {
+		return &ValidationError{Field: "chaos.kill_interval", Message: "kill interval cannot be negative"}
+	}
+
+	return nil
+}
+
+// validateDiagnostics validates diagnostics configuration
+func (v *DefaultValidator) validateDiagnostics(config DiagnosticsConfig) *ValidationError {
+	if !config.Enabled {
+		return nil
+	}
+
+	if _, err := net.ResolveTCPAddr("tcp", config.Addr); err != nil || config.Addr == "" {
+		return &ValidationError{Field: "diagnostics.addr", Message: "diagnostics need a valid listen address"}
+	}
+
+	// Profiles expose memory contents, so the endpoint is never open
+	if config.Token == "" {
+		return &ValidationError{Field: "diagnostics.token", Message: "diagnostics need a token"}
+	}
+
+	return nil
+}
+
+// validateConsensus validates the Raft group members
+func (v *DefaultValidator) validateConsensus(config ConsensusConfig) *ValidationError {
+	for _, member := range config.Members {
+		u, err := url.Parse(member)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &ValidationError{Field: "consensus.members", Message: fmt.Sprintf("invalid member URL: %s", member)}
+		}
+	}
+
+	return nil
+}
+
+// validateNotifications validates the SMTP server
+func (v *DefaultValidator) validateNotifications(config NotificationsConfig) *ValidationError {
+	if config.SMTPAddr == "" {
+		return nil
+	}
+
+	if _, _, err := net.SplitHostPort(config.SMTPAddr); err != nil {
+		return &ValidationError{Field: "notifications.smtp_addr", Message: "SMTP address must be host:port"}
+	}
+
+	if _, err := mail.ParseAddress(config.SMTPFrom); err != nil {
+		return &ValidationError{Field: "notifications.smtp_from", Message: "email needs a valid sender address"}
+	}
+
+	return nil
+}
+
+// validateScan validates content scanning configuration
+func (v *DefaultValidator) validateScan(config ScanConfig) *ValidationError {
+	if !config.Enabled {
+		return nil
+	}
+
+	switch strings.ToLower(config.Action) {
+	case "reject", "quarantine", "tag":
+	default:
+		return &ValidationError{Field: "scan.action", Message: "action must be reject, quarantine or tag"}
+	}
+
+	if config.MaxSize < 0 {
+		return &ValidationError{Field: "scan.max_size", Message: "max size cannot be negative"}
+	}
+
+	if config.Timeout < 0 {
+		return &ValidationError{Field: "scan.timeout", Message: "timeout cannot be negative"}
+	}
+
+	if config.ICAP != "" {
+		if u, err := url.Parse(config.ICAP); err != nil || u.Scheme != "icap" || u.Hostname() == "" {
+			return &ValidationError{Field: "scan.icap", Message: "ICAP URL must look like icap://host[:port]/service"}
+		}
+	}
+
+	return nil
+}
+
+// validatePolicy validates policy configuration
+func (v *DefaultValidator) validatePolicy(config PolicyConfig) *ValidationError {
+	if config.File == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(config.File); err != nil {
+		return &ValidationError{Field: "policy.file", Message: "policy file cannot be read"}
+	}
+
+	return nil
+}
+
+// validateHooks validates hooks configuration
+func (v *DefaultValidator) validateHooks(config HooksConfig) *ValidationError {
+	if config.File == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(config.File); err != nil {
+		return &ValidationError{Field: "hooks.file", Message: "hooks file cannot be read"}
+	}
+
+	return nil
+}
+
+// validateExports validates exports configuration
+func (v *DefaultValidator) validateExports(config ExportsConfig) *ValidationError {
+	if config.File == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(config.File); err != nil {
+		return &ValidationError{Field: "exports.file", Message: "exports file cannot be read"}
+	}
+
+	return nil
+}
+
+// mediaProfileName matches the names of stream profiles, which appear in
+// URLs
+var mediaProfileName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// pluginName is what the names of plugins may contain
+var pluginName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// validateMedia validates media configuration
+func (v *DefaultValidator) validateMedia(config MediaConfig) *ValidationError {
+	if !config.Enabled {
+		return nil
+	}
+
+	for _, size := range config.Sizes {
+		if size <= 0 {
+			return &ValidationError{Field: "media.sizes", Message: "thumbnail sizes must be positive"}
+		}
+	}
+
+	if config.Quality < 0 || config.Quality > 100 {
+		return &ValidationError{Field: "media.quality", Message: "quality must be between 0 and 100"}
+	}
+
+	if config.Timeout < 0 {
+		return &ValidationError{Field: "media.timeout", Message: "timeout cannot be negative"}
+	}
+
+	if config.SegmentSeconds < 0 {
+		return &ValidationError{Field: "media.segment_seconds", Message: "segment length cannot be negative"}
+	}
+
+	names := make(map[string]bool)
+	for _, profile := range config.Profiles {
+		if !mediaProfileName.MatchString(profile.Name) {
+			return &ValidationError{Field: "media.profiles", Message: fmt.Sprintf("invalid profile name %q: use letters, digits, - and _", profile.Name)}
+		}
+		if names[profile.Name] {
+			return &ValidationError{Field: "media.profiles", Message: fmt.Sprintf("duplicate profile %q", profile.Name)}
+		}
+		names[profile.Name] = true
+		if profile.Height < 0 {
+			return &ValidationError{Field: "media.profiles", Message: fmt.Sprintf("profile %q: height cannot be negative", profile.Name)}
+		}
+	}
+
+	return nil
+}
+
+// validatePlugins validates plugin configuration
+func (v *DefaultValidator) validatePlugins(config PluginsConfig) *ValidationError {
+	names := make(map[string]bool)
+	for _, p := range config.External {
+		if !pluginName.MatchString(p.Name) {
+			return &ValidationError{Field: "plugins.external", Message: fmt.Sprintf("invalid plugin name %q: use letters, digits, - and _", p.Name)}
+		}
+		if names[p.Name] {
+			return &ValidationError{Field: "plugins.external", Message: fmt.Sprintf("duplicate plugin %q", p.Name)}
+		}
+		names[p.Name] = true
+		if p.Path == "" {
+			return &ValidationError{Field: "plugins.external", Message: fmt.Sprintf("plugin %q: path is required", p.Name)}
+		}
+		if p.Timeout < 0 {
+			return &ValidationError{Field: "plugins.external", Message: fmt.Sprintf("plugin %q: timeout cannot be negative", p.Name)}
+		}
+		for _, env := range p.Env {
+			if key, _, ok := strings.Cut(env, "="); !ok || key == "" {
+				return &ValidationError{Field: "plugins.external", Message: fmt.Sprintf("plugin %q: environment variables must look like KEY=value", p.Name)}
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateJSON validates JSON document configuration
+func (v *DefaultValidator) validateJSON(config JSONConfig) *ValidationError {
+	if config.Schemas == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(config.Schemas); err != nil {
+		return &ValidationError{Field: "json.schemas", Message: "schema file cannot be read"}
+	}
+
+	return nil
+}
+
+// validateLogs validates append-only log configuration
+func (v *DefaultValidator) validateLogs(config LogsConfig) *ValidationError {
+	if config.SegmentBytes < 0 {
+		return &ValidationError{Field: "logs.segment_bytes", Message: "segment size cannot be negative"}
+	}
+
+	if config.MaxAge < 0 {
+		return &ValidationError{Field: "logs.max_age", Message: "max age cannot be negative"}
+	}
+
+	if config.MaxBytes < 0 {
+		return &ValidationError{Field: "logs.max_bytes", Message: "max bytes cannot be negative"}
+	}
+
+	return nil
+}
+
+// validateTopics validates durable topic configuration
+func (v *DefaultValidator) validateTopics(config TopicsConfig) *ValidationError {
+	if !config.Enabled {
+		return nil
+	}
+
+	if len(config.Prefixes) == 0 {
+		return &ValidationError{Field: "topics.prefixes", Message: "at least one prefix is required when durable topics are enabled"}
+	}
+
+	if config.SegmentBytes < 0 {
+		return &ValidationError{Field: "topics.segment_bytes", Message: "segment size cannot be negative"}
+	}
+
+	if config.MaxAge < 0 {
+		return &ValidationError{Field: "topics.max_age", Message: "max age cannot be negative"}
+	}
+
+	if config.MaxBytes < 0 {
+		return &ValidationError{Field: "topics.max_bytes", Message: "max bytes cannot be negative"}
+	}
+
+	if config.CommitInterval < 0 {
+		return &ValidationError{Field: "topics.commit_interval", Message: "commit interval cannot be negative"}
+	}
+
+	return nil
+}
+
+// validateEdge validates pull-through caching of an upstream cluster
+func (v *DefaultValidator) validateEdge(config EdgeConfig) *ValidationError {
+	if config.Upstream == "" {
+		return nil
+	}
+
+	if u, err := url.Parse(config.Upstream); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &ValidationError{Field: "edge.upstream", Message: "upstream must be an http or https URL"}
+	}
+
+	if config.TTL < 0 {
+		return &ValidationError{Field: "edge.ttl", Message: "TTL cannot be negative"}
+	}
+
+	return nil
+}
+
+// validateGeo validates routing clients to the nearest node
+func (v *DefaultValidator) validateGeo(config GeoConfig) *ValidationError {
+	if config.Location != "" {
+		if _, err := geo.ParsePoint(config.Location); err != nil {
+			return &ValidationError{Field: "geo.location", Message: "location must be latitude,longitude in decimal degrees"}
+		}
+	}
+
+	if config.PublicURL != "" {
+		if u, err := url.Parse(config.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &ValidationError{Field: "geo.public_url", Message: "public URL must be an http or https URL"}
+		}
+	}
+
+	for prefix := range config.Networks {
+		if _, err := netip.ParsePrefix(prefix); err != nil {
+			return &ValidationError{Field: "geo.networks", Message: fmt.Sprintf("invalid network %q", prefix)}
+		}
+	}
+
+	if config.DNSName != "" {
+		if !validPort(config.DNSPort) {
+			return &ValidationError{Field: "geo.dns_port", Message: "port must be between 1 and 65535"}
+		}
+		if config.DNSTTL < time.Second {
+			return &ValidationError{Field: "geo.dns_ttl", Message: "TTL must be at least one second"}
+		}
+	}
+
+	return nil
+}
+
+// validateDevices validates the device CA
+func (v *DefaultValidator) validateDevices(config DevicesConfig) *ValidationError {
+	if config.Enabled && config.Validity < time.Hour {
+		return &ValidationError{Field: "devices.validity", Message: "validity must be at least one hour"}
+	}
+
+	return nil
+}
+
 // Custom validators
 
 // PortValidator validates that ports are not conflicting
@@ -501,6 +1217,55 @@ func (v *PortValidator) Validate(config *Config) error {
 		}
 		ports[config.API.GRPC.Port] = "gRPC API"
 	}
+	// CoAP listens on UDP, so only the TCP listeners can collide
+	if config.API.WebSocket.Enabled {
+		if existing, exists := ports[config.API.WebSocket.Port]; exists {
+			return fmt.Errorf("port conflict: %s and WebSocket API both use port %d", existing, config.API.WebSocket.Port)
+		}
+		ports[config.API.WebSocket.Port] = "WebSocket API"
+	}
+	if config.API.SSE.Enabled {
+		if existing, exists := ports[config.API.SSE.Port]; exists {
+			return fmt.Errorf("port conflict: %s and SSE API both use port %d", existing, config.API.SSE.Port)
+		}
+		ports[config.API.SSE.Port] = "SSE API"
+	}
+	if config.API.MQTT.Enabled {
+		if existing, exists := ports[config.API.MQTT.Port]; exists {
+			return fmt.Errorf("port conflict: %s and MQTT broker both use port %d", existing, config.API.MQTT.Port)
+		}
+		ports[config.API.MQTT.Port] = "MQTT broker"
+		if config.API.MQTT.EnableWebSocket {
+			if existing, exists := ports[config.API.MQTT.WebSocketPort]; exists {
+				return fmt.Errorf("port conflict: %s and MQTT over WebSocket both use port %d", existing, config.API.MQTT.WebSocketPort)
+			}
+			ports[config.API.MQTT.WebSocketPort] = "MQTT over WebSocket"
+		}
+	}
+	if config.API.Kafka.Enabled {
+		if existing, exists := ports[config.API.Kafka.Port]; exists {
+			return fmt.Errorf("port conflict: %s and Kafka listener both use port %d", existing, config.API.Kafka.Port)
+		}
+		ports[config.API.Kafka.Port] = "Kafka listener"
+	}
+	if config.API.NFS.Enabled {
+		if existing, exists := ports[config.API.NFS.Port]; exists {
+			return fmt.Errorf("port conflict: %s and NFS gateway both use port %d", existing, config.API.NFS.Port)
+		}
+		ports[config.API.NFS.Port] = "NFS gateway"
+	}
+	if config.API.SFTP.Enabled {
+		if existing, exists := ports[config.API.SFTP.Port]; exists {
+			return fmt.Errorf("port conflict: %s and SFTP server both use port %d", existing, config.API.SFTP.Port)
+		}
+		ports[config.API.SFTP.Port] = "SFTP server"
+	}
+	if config.API.Gateway.Enabled {
+		if existing, exists := ports[config.API.Gateway.Port]; exists {
+			return fmt.Errorf("port conflict: %s and API gateway both use port %d", existing, config.API.Gateway.Port)
+		}
+		ports[config.API.Gateway.Port] = "API gateway"
+	}
 
 	return nil
 }
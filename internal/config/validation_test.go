@@ -227,6 +227,58 @@ func TestDefaultValidator_ValidateStorage(t *testing.T) {
 			hasError: true,
 			field:    "storage.retention_period",
 		},
+		{
+			name: "negative replication factor",
+			config: StorageConfig{
+				Root:              tempDir,
+				MaxFileSize:       1024 * 1024,
+				CompressionLevel:  6,
+				CleanupInterval:   time.Hour,
+				RetentionPeriod:   24 * time.Hour,
+				ReplicationFactor: -1,
+			},
+			hasError: true,
+			field:    "storage.replication_factor",
+		},
+		{
+			name: "negative capacity",
+			config: StorageConfig{
+				Root:             tempDir,
+				MaxFileSize:      1024 * 1024,
+				CompressionLevel: 6,
+				CleanupInterval:  time.Hour,
+				RetentionPeriod:  24 * time.Hour,
+				Capacity:         -1,
+			},
+			hasError: true,
+			field:    "storage.capacity",
+		},
+		{
+			name: "high watermark above 1",
+			config: StorageConfig{
+				Root:             tempDir,
+				MaxFileSize:      1024 * 1024,
+				CompressionLevel: 6,
+				CleanupInterval:  time.Hour,
+				RetentionPeriod:  24 * time.Hour,
+				HighWatermark:    1.5,
+			},
+			hasError: true,
+			field:    "storage.high_watermark",
+		},
+		{
+			name: "unknown hash",
+			config: StorageConfig{
+				Root:             tempDir,
+				MaxFileSize:      1024 * 1024,
+				CompressionLevel: 6,
+				CleanupInterval:  time.Hour,
+				RetentionPeriod:  24 * time.Hour,
+				Hash:             "md5",
+			},
+			hasError: true,
+			field:    "storage.hash",
+		},
 	}
 
 	for _, tt := range tests {
@@ -353,6 +405,48 @@ func TestDefaultValidator_ValidateNetwork(t *testing.T) {
 			hasError: true,
 			field:    "network.max_message_size",
 		},
+		{
+			name: "negative latency probe interval",
+			config: NetworkConfig{
+				BootstrapNodes:       []string{},
+				ConnectionTimeout:    time.Second,
+				ReadTimeout:          time.Second,
+				WriteTimeout:         time.Second,
+				KeepAliveInterval:    time.Second,
+				MaxMessageSize:       1024,
+				LatencyProbeInterval: -time.Second,
+			},
+			hasError: true,
+			field:    "network.latency_probe_interval",
+		},
+		{
+			name: "invalid peer ACL network",
+			config: NetworkConfig{
+				BootstrapNodes:    []string{},
+				ConnectionTimeout: time.Second,
+				ReadTimeout:       time.Second,
+				WriteTimeout:      time.Second,
+				KeepAliveInterval: time.Second,
+				MaxMessageSize:    1024,
+				ACL:               PeerACLConfig{DenyCIDRs: []string{"10.0.0.0/8", "10.0.0.0/33"}},
+			},
+			hasError: true,
+			field:    "network.acl.deny_cidrs[1]",
+		},
+		{
+			name: "negative message burst",
+			config: NetworkConfig{
+				BootstrapNodes:    []string{},
+				ConnectionTimeout: time.Second,
+				ReadTimeout:       time.Second,
+				WriteTimeout:      time.Second,
+				KeepAliveInterval: time.Second,
+				MaxMessageSize:    1024,
+				Limits:            ConnectionLimitsConfig{MessageRate: 10, MessageBurst: -1},
+			},
+			hasError: true,
+			field:    "network.limits.message_burst",
+		},
 	}
 
 	for _, tt := range tests {
@@ -405,6 +499,27 @@ func TestDefaultValidator_ValidateSecurity(t *testing.T) {
 			},
 			hasError: false,
 		},
+		{
+			name: "valid security config with ACME",
+			config: SecurityConfig{
+				AuthToken:           "valid-token",
+				TLS:                 true,
+				ACME:                ACMEConfig{Enabled: true, Domains: []string{"vault.example.com"}, CacheDir: "acme-cache"},
+				KeyRotationInterval: time.Hour,
+			},
+			hasError: false,
+		},
+		{
+			name: "ACME enabled but no domains",
+			config: SecurityConfig{
+				AuthToken:           "valid-token",
+				TLS:                 true,
+				ACME:                ACMEConfig{Enabled: true, CacheDir: "acme-cache"},
+				KeyRotationInterval: time.Hour,
+			},
+			hasError: true,
+			field:    "security.acme.domains",
+		},
 		{
 			name: "empty auth token",
 			config: SecurityConfig{
@@ -1107,6 +1222,338 @@ func TestDefaultValidator_ValidatePerformance(t *testing.T) {
 	}
 }
 
+func TestDefaultValidator_ValidateChaos(t *testing.T) {
+	validator := &DefaultValidator{}
+
+	tests := []struct {
+		name     string
+		config   ChaosConfig
+		hasError bool
+		field    string
+	}{
+		{
+			name:     "disabled chaos config",
+			config:   ChaosConfig{},
+			hasError: false,
+		},
+		{
+			name: "valid chaos config",
+			config: ChaosConfig{
+				Enabled:      true,
+				DropRate:     0.1,
+				DelayRate:    1,
+				MinDelay:     10 * time.Millisecond,
+				MaxDelay:     time.Second,
+				KillInterval: time.Minute,
+			},
+			hasError: false,
+		},
+		{
+			name:     "rate above one",
+			config:   ChaosConfig{CorruptRate: 1.5},
+			hasError: true,
+			field:    "chaos.corrupt_rate",
+		},
+		{
+			name:     "max delay below min delay",
+			config:   ChaosConfig{MinDelay: time.Second, MaxDelay: time.Millisecond},
+			hasError: true,
+			field:    "chaos.max_delay",
+		},
+		{
+			name:     "negative kill interval",
+			config:   ChaosConfig{KillInterval: -time.Second},
+			hasError: true,
+			field:    "chaos.kill_interval",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.validateChaos(tt.config)
+			if tt.hasError {
+				assert.NotNil(t, err)
+				assert.Equal(t, tt.field, err.Field)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestDefaultValidator_ValidateDiagnostics(t *testing.T) {
+	validator := &DefaultValidator{}
+
+	tests := []struct {
+		name     string
+		config   DiagnosticsConfig
+		hasError bool
+		field    string
+	}{
+		{
+			name:     "disabled diagnostics config",
+			config:   DiagnosticsConfig{},
+			hasError: false,
+		},
+		{
+			name:     "valid diagnostics config",
+			config:   DiagnosticsConfig{Enabled: true, Addr: "127.0.0.1:6060", Token: "secret"},
+			hasError: false,
+		},
+		{
+			name:     "missing token",
+			config:   DiagnosticsConfig{Enabled: true, Addr: "127.0.0.1:6060"},
+			hasError: true,
+			field:    "diagnostics.token",
+		},
+		{
+			name:     "invalid address",
+			config:   DiagnosticsConfig{Enabled: true, Addr: "localhost", Token: "secret"},
+			hasError: true,
+			field:    "diagnostics.addr",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.validateDiagnostics(tt.config)
+			if tt.hasError {
+				assert.NotNil(t, err)
+				assert.Equal(t, tt.field, err.Field)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestDefaultValidator_ValidateConsensus(t *testing.T) {
+	validator := &DefaultValidator{}
+
+	tests := []struct {
+		name     string
+		config   ConsensusConfig
+		hasError bool
+	}{
+		{
+			name:     "no raft group",
+			config:   ConsensusConfig{},
+			hasError: false,
+		},
+		{
+			name:     "valid members",
+			config:   ConsensusConfig{Members: []string{"http://10.0.0.1:7000", "https://raft.example.com"}},
+			hasError: false,
+		},
+		{
+			name:     "member without scheme",
+			config:   ConsensusConfig{Members: []string{"10.0.0.1:7000"}},
+			hasError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.validateConsensus(tt.config)
+			if tt.hasError {
+				assert.NotNil(t, err)
+				assert.Equal(t, "consensus.members", err.Field)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestDefaultValidator_ValidateNotifications(t *testing.T) {
+	validator := &DefaultValidator{}
+
+	tests := []struct {
+		name     string
+		config   NotificationsConfig
+		hasError bool
+		field    string
+	}{
+		{
+			name:     "email disabled",
+			config:   NotificationsConfig{},
+			hasError: false,
+		},
+		{
+			name:     "valid smtp server",
+			config:   NotificationsConfig{SMTPAddr: "smtp.example.com:587", SMTPFrom: "PeerVault <vault@example.com>"},
+			hasError: false,
+		},
+		{
+			name:     "address without port",
+			config:   NotificationsConfig{SMTPAddr: "smtp.example.com", SMTPFrom: "vault@example.com"},
+			hasError: true,
+			field:    "notifications.smtp_addr",
+		},
+		{
+			name:     "missing sender",
+			config:   NotificationsConfig{SMTPAddr: "smtp.example.com:25"},
+			hasError: true,
+			field:    "notifications.smtp_from",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.validateNotifications(tt.config)
+			if tt.hasError {
+				assert.NotNil(t, err)
+				assert.Equal(t, tt.field, err.Field)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestDefaultValidator_ValidateScan(t *testing.T) {
+	validator := &DefaultValidator{}
+
+	tests := []struct {
+		name     string
+		config   ScanConfig
+		hasError bool
+		field    string
+	}{
+		{
+			name:     "scanning disabled",
+			config:   ScanConfig{Action: "delete"},
+			hasError: false,
+		},
+		{
+			name:     "valid clamd and icap",
+			config:   ScanConfig{Enabled: true, Action: "quarantine", Clamd: "unix:/run/clamd.sock", ICAP: "icap://scanner/avscan"},
+			hasError: false,
+		},
+		{
+			name:     "unknown action",
+			config:   ScanConfig{Enabled: true, Action: "delete"},
+			hasError: true,
+			field:    "scan.action",
+		},
+		{
+			name:     "http icap url",
+			config:   ScanConfig{Enabled: true, Action: "tag", ICAP: "http://scanner:1344/avscan"},
+			hasError: true,
+			field:    "scan.icap",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.validateScan(tt.config)
+			if tt.hasError {
+				assert.NotNil(t, err)
+				assert.Equal(t, tt.field, err.Field)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestDefaultValidator_ValidatePlugins(t *testing.T) {
+	validator := &DefaultValidator{}
+
+	tests := []struct {
+		name    string
+		plugins []PluginConfig
+		message string
+	}{
+		{
+			name:    "no plugins",
+			plugins: nil,
+		},
+		{
+			name: "valid plugins",
+			plugins: []PluginConfig{
+				{Name: "ldap-auth", Path: "/usr/lib/peervault/ldap-auth", Env: []string{"LDAP_URL=ldaps://dir"}},
+				{Name: "deny_list", Path: "deny-list", Args: []string{"-words", "secret"}, Timeout: time.Second},
+			},
+		},
+		{
+			name:    "invalid name",
+			plugins: []PluginConfig{{Name: "ldap auth", Path: "ldap-auth"}},
+			message: "invalid plugin name",
+		},
+		{
+			name:    "duplicate name",
+			plugins: []PluginConfig{{Name: "scanner", Path: "a"}, {Name: "scanner", Path: "b"}},
+			message: "duplicate plugin",
+		},
+		{
+			name:    "missing path",
+			plugins: []PluginConfig{{Name: "scanner"}},
+			message: "path is required",
+		},
+		{
+			name:    "malformed environment",
+			plugins: []PluginConfig{{Name: "scanner", Path: "a", Env: []string{"TOKEN"}}},
+			message: "KEY=value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.validatePlugins(PluginsConfig{External: tt.plugins})
+			if tt.message != "" {
+				assert.NotNil(t, err)
+				assert.Equal(t, "plugins.external", err.Field)
+				assert.Contains(t, err.Message, tt.message)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestDefaultValidator_ValidatePolicy(t *testing.T) {
+	validator := &DefaultValidator{}
+
+	assert.Nil(t, validator.validatePolicy(PolicyConfig{}))
+
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("rules: []\n"), 0644))
+	assert.Nil(t, validator.validatePolicy(PolicyConfig{File: path}))
+
+	err := validator.validatePolicy(PolicyConfig{File: path + ".missing"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "policy.file", err.Field)
+}
+
+func TestDefaultValidator_ValidateHooks(t *testing.T) {
+	validator := &DefaultValidator{}
+
+	assert.Nil(t, validator.validateHooks(HooksConfig{}))
+
+	path := filepath.Join(t.TempDir(), "hooks.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("hooks: []\n"), 0644))
+	assert.Nil(t, validator.validateHooks(HooksConfig{File: path}))
+
+	err := validator.validateHooks(HooksConfig{File: path + ".missing"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "hooks.file", err.Field)
+}
+
+func TestDefaultValidator_ValidateExports(t *testing.T) {
+	validator := &DefaultValidator{}
+
+	assert.Nil(t, validator.validateExports(ExportsConfig{}))
+
+	path := filepath.Join(t.TempDir(), "exports.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("exports: []\n"), 0644))
+	assert.Nil(t, validator.validateExports(ExportsConfig{File: path}))
+
+	err := validator.validateExports(ExportsConfig{File: path + ".missing"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "exports.file", err.Field)
+}
+
 func TestPortValidator_Validate(t *testing.T) {
 	validator := &PortValidator{}
 
@@ -1177,6 +1624,25 @@ func TestPortValidator_Validate(t *testing.T) {
 			},
 			hasError: false,
 		},
+		{
+			name: "MQTT over WebSocket and SSE port conflict",
+			config: &Config{
+				API: APIConfig{
+					SSE: SSEConfig{
+						Enabled: true,
+						Port:    8084,
+					},
+					MQTT: MQTTConfig{
+						Enabled:         true,
+						Port:            1883,
+						EnableWebSocket: true,
+						WebSocketPort:   8084,
+					},
+				},
+			},
+			hasError: true,
+			contains: "MQTT over WebSocket",
+		},
 	}
 
 	for _, tt := range tests {
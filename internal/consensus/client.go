@@ -0,0 +1,111 @@
+package consensus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LeaseRequest acquires or renews a lease for a holder
+type LeaseRequest struct {
+	Holder     string `json:"holder"`
+	TTLSeconds int64  `json:"ttl_seconds"`
+}
+
+// Client uses the locks and leases of a Raft group from outside it, for
+// servers that expose them without being members. It sends requests to
+// the member that answered last and moves on to the next one while a
+// member is unreachable or has no leader. Reads are answered from the
+// state a member applied, which may trail the leader briefly but includes
+// the client's own acquisitions and releases; holders use the fencing
+// token, not reads, to guard against a lease they lost.
+type Client struct {
+	urls      []string
+	transport *transport
+
+	mu   sync.Mutex
+	next int
+}
+
+// NewClient creates a client for the members at urls, such as
+// http://10.0.0.1:7000. A nil httpClient uses one with a 10s timeout.
+func NewClient(urls []string, token string, httpClient *http.Client) (*Client, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("consensus: client needs at least one member")
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	c := &Client{transport: &transport{client: httpClient, token: token}}
+	for _, u := range urls {
+		c.urls = append(c.urls, strings.TrimRight(u, "/"))
+	}
+	return c, nil
+}
+
+// Leases returns the unexpired leases
+func (c *Client) Leases(ctx context.Context) ([]Lease, error) {
+	var leases []Lease
+	err := c.do(ctx, http.MethodGet, "/cluster/leases", nil, &leases)
+	return leases, err
+}
+
+// Lease returns an unexpired lease, or ErrNotFound
+func (c *Client) Lease(ctx context.Context, name string) (Lease, error) {
+	var lease Lease
+	err := c.do(ctx, http.MethodGet, leasePath(name), nil, &lease)
+	return lease, err
+}
+
+// AcquireLease grants the lease to req.Holder, or renews it when the holder
+// already holds it, and returns it with its fencing token. It fails with
+// ErrLeaseHeld while another holder's lease has not expired.
+func (c *Client) AcquireLease(ctx context.Context, name string, req LeaseRequest) (Lease, error) {
+	var lease Lease
+	err := c.do(ctx, http.MethodPut, leasePath(name), req, &lease)
+	return lease, err
+}
+
+// ReleaseLease gives up a lease before it expires. It fails with
+// ErrLeaseHeld when holder does not hold it and ErrNotFound when it has
+// expired already.
+func (c *Client) ReleaseLease(ctx context.Context, name, holder string) error {
+	return c.do(ctx, http.MethodDelete, leasePath(name)+"?holder="+url.QueryEscape(holder), nil, nil)
+}
+
+func leasePath(name string) string {
+	return "/cluster/leases/" + url.PathEscape(name)
+}
+
+// do tries the members in turn until one answers. Answers are final; only
+// unreachable members and members without a leader are skipped.
+func (c *Client) do(ctx context.Context, method, path string, req, resp any) error {
+	c.mu.Lock()
+	first := c.next
+	c.mu.Unlock()
+
+	var err error
+	for i := range c.urls {
+		n := (first + i) % len(c.urls)
+		err = c.transport.do(ctx, method, c.urls[n]+path, req, resp)
+		var remote *RemoteError
+		if err == nil || errors.As(err, &remote) {
+			c.mu.Lock()
+			c.next = n
+			c.mu.Unlock()
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	if errors.Is(err, ErrNoLeader) {
+		return err
+	}
+	return fmt.Errorf("%w: no member reachable: %v", ErrNoLeader, err)
+}
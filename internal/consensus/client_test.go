@@ -0,0 +1,57 @@
+package consensus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientLeases(t *testing.T) {
+	c := newTestCluster(t, 3)
+	c.leader()
+
+	// An unreachable member is skipped
+	urls := []string{"http://127.0.0.1:1"}
+	for _, p := range c.peers {
+		urls = append(urls, p.URL)
+	}
+	client, err := NewClient(urls, "secret", nil)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lease, err := client.AcquireLease(ctx, "gc", LeaseRequest{Holder: "a", TTLSeconds: 30})
+	require.NoError(t, err)
+	assert.Equal(t, "a", lease.Holder)
+	assert.Positive(t, lease.Token)
+
+	_, err = client.AcquireLease(ctx, "gc", LeaseRequest{Holder: "b", TTLSeconds: 30})
+	assert.ErrorIs(t, err, ErrLeaseHeld)
+
+	// Renewing extends the lease and keeps the fencing token
+	renewed, err := client.AcquireLease(ctx, "gc", LeaseRequest{Holder: "a", TTLSeconds: 60})
+	require.NoError(t, err)
+	assert.Equal(t, lease.Token, renewed.Token)
+	assert.True(t, renewed.ExpiresAt.After(lease.ExpiresAt))
+
+	leases, err := client.Leases(ctx)
+	require.NoError(t, err)
+	require.Len(t, leases, 1)
+
+	assert.ErrorIs(t, client.ReleaseLease(ctx, "gc", "b"), ErrLeaseHeld)
+	require.NoError(t, client.ReleaseLease(ctx, "gc", "a"))
+	_, err = client.Lease(ctx, "gc")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// The next holder gets a newer token
+	next, err := client.AcquireLease(ctx, "gc", LeaseRequest{Holder: "b", TTLSeconds: 30})
+	require.NoError(t, err)
+	assert.Greater(t, next.Token, lease.Token)
+
+	_, err = client.AcquireLease(ctx, "gc", LeaseRequest{Holder: "b"})
+	var remote *RemoteError
+	require.ErrorAs(t, err, &remote, "a lease needs a ttl")
+}
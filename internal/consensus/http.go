@@ -0,0 +1,397 @@
+package consensus
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// VoteRequest asks a member to vote for a candidate
+type VoteRequest struct {
+	Term         uint64 `json:"term"`
+	CandidateID  string `json:"candidate_id"`
+	LastLogIndex uint64 `json:"last_log_index"`
+	LastLogTerm  uint64 `json:"last_log_term"`
+}
+
+// VoteResponse answers a VoteRequest
+type VoteResponse struct {
+	Term        uint64 `json:"term"`
+	VoteGranted bool   `json:"vote_granted"`
+}
+
+// AppendRequest replicates entries to a follower; without entries it is a
+// heartbeat
+type AppendRequest struct {
+	Term         uint64  `json:"term"`
+	LeaderID     string  `json:"leader_id"`
+	PrevLogIndex uint64  `json:"prev_log_index"`
+	PrevLogTerm  uint64  `json:"prev_log_term"`
+	Entries      []Entry `json:"entries,omitempty"`
+	LeaderCommit uint64  `json:"leader_commit"`
+}
+
+// AppendResponse answers an AppendRequest
+type AppendResponse struct {
+	Term    uint64 `json:"term"`
+	Success bool   `json:"success"`
+	// ConflictIndex is where the leader should retry from when the
+	// follower's log does not match
+	ConflictIndex uint64 `json:"conflict_index,omitempty"`
+}
+
+// SnapshotRequest sends a follower the leader's snapshot
+type SnapshotRequest struct {
+	Term     uint64 `json:"term"`
+	LeaderID string `json:"leader_id"`
+	Index    uint64 `json:"index"`
+	LastTerm uint64 `json:"last_term"`
+	Data     []byte `json:"data"`
+}
+
+// SnapshotResponse answers a SnapshotRequest
+type SnapshotResponse struct {
+	Term uint64 `json:"term"`
+}
+
+// ProposeResponse answers a command forwarded to the leader
+type ProposeResponse struct {
+	Index uint64 `json:"index"`
+}
+
+// transport sends requests to the other members over HTTP. It also serves
+// the Client of operators.
+type transport struct {
+	client *http.Client
+	token  string
+}
+
+var _ Transport = (*transport)(nil)
+
+func (t *transport) RequestVote(url string, req VoteRequest) (VoteResponse, error) {
+	var resp VoteResponse
+	err := t.call(context.Background(), url+"/raft/vote", req, &resp)
+	return resp, err
+}
+
+func (t *transport) AppendEntries(url string, req AppendRequest) (AppendResponse, error) {
+	var resp AppendResponse
+	err := t.call(context.Background(), url+"/raft/append", req, &resp)
+	return resp, err
+}
+
+func (t *transport) InstallSnapshot(url string, req SnapshotRequest) (SnapshotResponse, error) {
+	var resp SnapshotResponse
+	err := t.call(context.Background(), url+"/raft/snapshot", req, &resp)
+	return resp, err
+}
+
+func (t *transport) Propose(ctx context.Context, url string, command []byte) (uint64, error) {
+	var resp ProposeResponse
+	err := t.call(ctx, url+"/raft/propose", json.RawMessage(command), &resp)
+	return resp.Index, err
+}
+
+// call posts a JSON request
+func (t *transport) call(ctx context.Context, url string, req, resp any) error {
+	return t.do(ctx, http.MethodPost, url, req, resp)
+}
+
+// do sends a request with an optional JSON body. Errors answered by the
+// member are returned with their message, keeping the identity of the
+// rejections listed in rejections, and ErrNoLeader when the member has no
+// leader either.
+func (t *transport) do(ctx context.Context, method, url string, req, resp any) error {
+	var body io.Reader
+	if req != nil {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	if req != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if t.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+t.token)
+	}
+
+	httpResp, err := t.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+
+	switch httpResp.StatusCode {
+	case http.StatusOK:
+		if resp == nil {
+			return nil
+		}
+		return json.NewDecoder(httpResp.Body).Decode(resp)
+	case http.StatusServiceUnavailable:
+		return ErrNoLeader
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(httpResp.Body, 4096))
+		return &RemoteError{Message: strings.TrimSpace(string(msg)), Status: httpResp.StatusCode}
+	}
+}
+
+// RemoteError is an error a member answered a request with
+type RemoteError struct {
+	Message string
+	Status  int
+}
+
+func (e *RemoteError) Error() string { return e.Message }
+
+// Unwrap returns the rejection the status stands for, so errors.Is works
+// for commands rejected on another member
+func (e *RemoteError) Unwrap() error {
+	for _, r := range rejections {
+		if r.status == e.Status {
+			return r.err
+		}
+	}
+	return nil
+}
+
+// rejections are the command rejections that keep their identity when a
+// command was applied on another member
+var rejections = []struct {
+	err    error
+	status int
+}{
+	{ErrNotFound, http.StatusNotFound},
+	{ErrLeaseHeld, http.StatusLocked},
+}
+
+// Handler exposes a node to the other members and its cluster state to
+// operators:
+//
+//	POST   /raft/vote             vote requests from candidates
+//	POST   /raft/append           entries and heartbeats from the leader
+//	POST   /raft/snapshot         snapshots from the leader
+//	POST   /raft/propose          commands forwarded by followers
+//	GET    /cluster/status        the node's role, term and replication progress
+//	GET    /cluster/state         the cluster state as applied on this node
+//	POST   /cluster/commands      submits a Command from any member
+//	GET    /cluster/leases        the unexpired leases
+//	GET    /cluster/leases/{name} one unexpired lease
+//	PUT    /cluster/leases/{name} acquires or renews a lease
+//	DELETE /cluster/leases/{name} releases a lease held by ?holder=
+//
+// When the node has an auth token every request must carry it.
+func Handler(node *Node, state *ClusterState) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /raft/vote", func(w http.ResponseWriter, r *http.Request) {
+		var req VoteRequest
+		if decode(w, r, &req) {
+			respond(w, node.HandleVote(req))
+		}
+	})
+	mux.HandleFunc("POST /raft/append", func(w http.ResponseWriter, r *http.Request) {
+		var req AppendRequest
+		if decode(w, r, &req) {
+			respond(w, node.HandleAppend(req))
+		}
+	})
+	mux.HandleFunc("POST /raft/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		var req SnapshotRequest
+		if !decode(w, r, &req) {
+			return
+		}
+		resp, err := node.HandleSnapshot(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respond(w, resp)
+	})
+	mux.HandleFunc("POST /raft/propose", func(w http.ResponseWriter, r *http.Request) {
+		var command json.RawMessage
+		if !decode(w, r, &command) {
+			return
+		}
+		// Forwarded commands are not forwarded again, so a stale view of
+		// the leader cannot bounce a command between followers
+		index, err := node.Propose(r.Context(), command)
+		if err != nil {
+			writeProposeError(w, err)
+			return
+		}
+		respond(w, ProposeResponse{Index: index})
+	})
+
+	mux.HandleFunc("GET /cluster/status", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, node.Status())
+	})
+	mux.HandleFunc("GET /cluster/state", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, state.View())
+	})
+	mux.HandleFunc("POST /cluster/commands", func(w http.ResponseWriter, r *http.Request) {
+		var cmd Command
+		if !decode(w, r, &cmd) {
+			return
+		}
+		if err := cmd.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		index, err := submitCommand(r.Context(), node, &cmd)
+		if err != nil {
+			writeProposeError(w, err)
+			return
+		}
+		respond(w, ProposeResponse{Index: index})
+	})
+
+	mux.HandleFunc("GET /cluster/leases", func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		leases := []Lease{}
+		for _, l := range state.View().Leases {
+			if now.Before(l.ExpiresAt) {
+				leases = append(leases, l)
+			}
+		}
+		respond(w, leases)
+	})
+	mux.HandleFunc("GET /cluster/leases/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		lease, ok := state.Lease(name, time.Now())
+		if !ok {
+			http.Error(w, fmt.Sprintf("%v: lease %s", ErrNotFound, name), http.StatusNotFound)
+			return
+		}
+		respond(w, lease)
+	})
+	mux.HandleFunc("PUT /cluster/leases/{name}", func(w http.ResponseWriter, r *http.Request) {
+		var req LeaseRequest
+		if !decode(w, r, &req) {
+			return
+		}
+		lease, err := acquireLease(r.Context(), node, state, r.PathValue("name"), req)
+		if err != nil {
+			writeProposeError(w, err)
+			return
+		}
+		respond(w, lease)
+	})
+	mux.HandleFunc("DELETE /cluster/leases/{name}", func(w http.ResponseWriter, r *http.Request) {
+		cmd := Command{Type: CommandReleaseLease, Lease: &Lease{Name: r.PathValue("name"), Holder: r.URL.Query().Get("holder")}}
+		if err := cmd.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		index, err := submitCommand(r.Context(), node, &cmd)
+		if err == nil {
+			// Reads of the same client go to this member, so they see the release
+			err = state.WaitApplied(r.Context(), index)
+		}
+		if err != nil {
+			writeProposeError(w, err)
+			return
+		}
+		respond(w, struct{}{})
+	})
+
+	return authorize(node.opts.AuthToken, mux)
+}
+
+// submitCommand stamps a validated command with the time and submits it
+// to the group. Stamping it once keeps applying it deterministic on every
+// member.
+func submitCommand(ctx context.Context, node *Node, cmd *Command) (uint64, error) {
+	cmd.Time = time.Now().UTC()
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return 0, err
+	}
+	return node.Submit(ctx, data)
+}
+
+// acquireLease acquires or renews a lease and returns it once this member
+// applied the acquisition, so it carries the fencing token
+func acquireLease(ctx context.Context, node *Node, state *ClusterState, name string, req LeaseRequest) (Lease, error) {
+	cmd := Command{Type: CommandAcquireLease, Lease: &Lease{Name: name, Holder: req.Holder, TTLSeconds: req.TTLSeconds}}
+	if err := cmd.Validate(); err != nil {
+		return Lease{}, &RemoteError{Message: err.Error(), Status: http.StatusBadRequest}
+	}
+	index, err := submitCommand(ctx, node, &cmd)
+	if err != nil {
+		return Lease{}, err
+	}
+	if err := state.WaitApplied(ctx, index); err != nil {
+		return Lease{}, err
+	}
+	lease, ok := state.Lease(name, cmd.Time)
+	if !ok || lease.Holder != req.Holder {
+		// Released or taken over by a later command in the meantime
+		return Lease{}, fmt.Errorf("%w: lease %s", ErrNotFound, name)
+	}
+	return lease, nil
+}
+
+// authorize requires the bearer token on every request when token is set
+func authorize(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<20)).Decode(v); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func respond(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeProposeError reports a failed proposal: no leader and lost
+// leadership are worth retrying, anything else rejected the command
+func writeProposeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotLeader), errors.Is(err, ErrNoLeader), errors.Is(err, ErrLeadershipLost), errors.Is(err, ErrStopped):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+	default:
+		var remote *RemoteError
+		if errors.As(err, &remote) {
+			http.Error(w, err.Error(), remote.Status)
+			return
+		}
+		for _, r := range rejections {
+			if errors.Is(err, r.err) {
+				http.Error(w, err.Error(), r.status)
+				return
+			}
+		}
+		http.Error(w, err.Error(), http.StatusConflict)
+	}
+}
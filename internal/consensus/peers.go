@@ -0,0 +1,28 @@
+package consensus
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TokenEnv is the environment variable holding the token the members of a
+// group authenticate each other with
+const TokenEnv = "PEERVAULT_RAFT_TOKEN"
+
+// ParsePeers parses a comma-separated list of id=url members, such as
+// "a=http://10.0.0.1:7000,b=http://10.0.0.2:7000"
+func ParsePeers(s string) ([]Peer, error) {
+	var peers []Peer
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, url, ok := strings.Cut(item, "=")
+		if !ok || id == "" || url == "" {
+			return nil, fmt.Errorf("consensus: invalid peer %q, want id=url", item)
+		}
+		peers = append(peers, Peer{ID: strings.TrimSpace(id), URL: strings.TrimRight(strings.TrimSpace(url), "/")})
+	}
+	return peers, nil
+}
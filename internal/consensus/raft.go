@@ -0,0 +1,879 @@
+// Package consensus keeps cluster metadata consistent across nodes with the
+// Raft consensus algorithm. A small group of voting nodes replicates a log
+// of commands; a command is applied once a majority of the group stored it,
+// so a partitioned minority can never commit changes that diverge from the
+// rest of the cluster. Only metadata goes through the log: file contents
+// are still replicated by the file servers.
+package consensus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotLeader is returned by Propose on nodes that are not the leader
+	ErrNotLeader = errors.New("consensus: not the leader")
+	// ErrNoLeader is returned when no leader is known, such as during an
+	// election or while a majority of the group is unreachable
+	ErrNoLeader = errors.New("consensus: no leader elected")
+	// ErrLeadershipLost is returned when the leader stepped down before a
+	// proposed command committed; the command may or may not be applied
+	ErrLeadershipLost = errors.New("consensus: leadership lost before the command committed")
+	// ErrStopped is returned once the node has been stopped
+	ErrStopped = errors.New("consensus: node stopped")
+)
+
+// Role is the part a node currently plays in the group
+type Role string
+
+const (
+	RoleFollower  Role = "follower"
+	RoleCandidate Role = "candidate"
+	RoleLeader    Role = "leader"
+)
+
+// maxAppendEntries bounds the entries sent in one append request
+const maxAppendEntries = 256
+
+// Peer is a voting member of the group
+type Peer struct {
+	ID string `json:"id"`
+	// URL is the base URL of the member's consensus endpoints
+	URL string `json:"url"`
+}
+
+// StateMachine is the state the log is applied to. Every node applies the
+// same commands in the same order, so Apply must only depend on the
+// command and the current state.
+type StateMachine interface {
+	// Apply applies a committed command at the given log index. An error
+	// rejects the command on every node alike and is returned to whoever
+	// proposed it.
+	Apply(index uint64, command []byte) error
+	// Snapshot serializes the state so the log before it can be discarded
+	Snapshot() ([]byte, error)
+	// Restore replaces the state with a snapshot
+	Restore(data []byte) error
+}
+
+// Options configures a Node
+type Options struct {
+	// ID identifies this node; it must be one of Peers
+	ID string
+	// Peers are all voting members of the group, this node included
+	Peers []Peer
+	// Dir persists the term, vote, log and snapshots; empty keeps them in
+	// memory, which is only safe for tests
+	Dir string
+	// AuthToken is sent to and required from the other members
+	AuthToken string
+	// ElectionTimeout is the minimum time without hearing from a leader
+	// before starting an election; each node waits a random time between
+	// one and two timeouts. Defaults to one second.
+	ElectionTimeout time.Duration
+	// HeartbeatInterval is how often the leader contacts followers;
+	// defaults to a tenth of the election timeout
+	HeartbeatInterval time.Duration
+	// SnapshotThreshold is the number of applied entries after which the
+	// log is compacted into a snapshot; defaults to 1024
+	SnapshotThreshold uint64
+	HTTPClient        *http.Client
+	// Runtime runs the node's timers and background work; nil uses the
+	// wall clock and goroutines
+	Runtime Runtime
+	// Transport carries the requests to the other members; nil uses HTTP
+	// with HTTPClient and AuthToken
+	Transport Transport
+}
+
+// Entry is one command in the replicated log
+type Entry struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	// Command is empty for the entry a new leader appends to commit the
+	// entries of earlier terms
+	Command json.RawMessage `json:"command,omitempty"`
+}
+
+// PeerStatus is the replication progress of a member, as seen by the leader
+type PeerStatus struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	MatchIndex  uint64    `json:"match_index"`
+	LastContact time.Time `json:"last_contact,omitempty"`
+}
+
+// Status reports the state of a node
+type Status struct {
+	ID            string       `json:"id"`
+	Role          Role         `json:"role"`
+	Term          uint64       `json:"term"`
+	Leader        string       `json:"leader,omitempty"`
+	LeaderURL     string       `json:"leader_url,omitempty"`
+	LastIndex     uint64       `json:"last_index"`
+	CommitIndex   uint64       `json:"commit_index"`
+	AppliedIndex  uint64       `json:"applied_index"`
+	SnapshotIndex uint64       `json:"snapshot_index"`
+	Peers         []PeerStatus `json:"peers"`
+}
+
+// Node is a member of a Raft group
+type Node struct {
+	opts      Options
+	fsm       StateMachine
+	peers     map[string]string // URL by ID
+	others    []string          // IDs of the other members, in the order of Peers
+	runtime   Runtime
+	transport Transport
+
+	mu          sync.Mutex
+	role        Role
+	term        uint64
+	votedFor    string
+	leader      string
+	log         []Entry // entries after the snapshot
+	snapIndex   uint64
+	snapTerm    uint64
+	snapshot    []byte
+	commitIndex uint64
+	lastApplied uint64
+	deadline    time.Time // of the election timer
+
+	// Leader state, reset on every election
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	lastContact map[string]time.Time
+	inflight    map[string]bool
+
+	waiters map[uint64]waiter // proposals by log index
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stopTicks func() // set once started
+	stopch    chan struct{}
+}
+
+// waiter is a proposal waiting for its entry to be applied
+type waiter struct {
+	term   uint64
+	result chan error
+}
+
+// NewNode creates a node, restoring its persisted state and applying the
+// persisted snapshot to fsm
+func NewNode(opts Options, fsm StateMachine) (*Node, error) {
+	if opts.ElectionTimeout <= 0 {
+		opts.ElectionTimeout = time.Second
+	}
+	if opts.HeartbeatInterval <= 0 {
+		opts.HeartbeatInterval = opts.ElectionTimeout / 10
+	}
+	if opts.SnapshotThreshold == 0 {
+		opts.SnapshotThreshold = 1024
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: opts.ElectionTimeout}
+	}
+	if opts.Runtime == nil {
+		opts.Runtime = realRuntime{}
+	}
+	if opts.Transport == nil {
+		opts.Transport = &transport{client: opts.HTTPClient, token: opts.AuthToken}
+	}
+
+	peers := make(map[string]string, len(opts.Peers))
+	var others []string
+	for _, p := range opts.Peers {
+		if p.ID == "" {
+			return nil, errors.New("consensus: peer without an ID")
+		}
+		if _, dup := peers[p.ID]; dup {
+			return nil, fmt.Errorf("consensus: duplicate peer %q", p.ID)
+		}
+		peers[p.ID] = p.URL
+		if p.ID != opts.ID {
+			others = append(others, p.ID)
+		}
+	}
+	if _, ok := peers[opts.ID]; !ok {
+		return nil, fmt.Errorf("consensus: node %q is not one of the peers", opts.ID)
+	}
+
+	n := &Node{
+		opts:      opts,
+		fsm:       fsm,
+		peers:     peers,
+		others:    others,
+		runtime:   opts.Runtime,
+		transport: opts.Transport,
+		role:      RoleFollower,
+		waiters:   make(map[uint64]waiter),
+		stopch:    make(chan struct{}),
+	}
+	if err := n.load(); err != nil {
+		return nil, err
+	}
+	n.resetElectionTimerLocked()
+	return n, nil
+}
+
+// Start runs elections and replication in the background
+func (n *Node) Start() {
+	n.startOnce.Do(func() { n.stopTicks = n.runtime.Every(n.opts.HeartbeatInterval, n.tick) })
+}
+
+// Stop stops the node; pending proposals fail with ErrStopped
+func (n *Node) Stop() {
+	n.stopOnce.Do(func() {
+		close(n.stopch)
+		// A node that never started has nothing to wait for
+		n.startOnce.Do(func() {})
+		if n.stopTicks != nil {
+			n.stopTicks()
+		}
+	})
+}
+
+// tick sends heartbeats as leader and starts an election when the leader
+// has not been heard from in time
+func (n *Node) tick() {
+	n.mu.Lock()
+	role, expired := n.role, n.runtime.Now().After(n.deadline)
+	n.mu.Unlock()
+
+	switch {
+	case role == RoleLeader:
+		n.replicateAll()
+	case expired:
+		n.campaign()
+	}
+}
+
+// Status returns the current state of the node
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	status := Status{
+		ID:            n.opts.ID,
+		Role:          n.role,
+		Term:          n.term,
+		Leader:        n.leader,
+		LeaderURL:     n.peers[n.leader],
+		LastIndex:     n.lastIndexLocked(),
+		CommitIndex:   n.commitIndex,
+		AppliedIndex:  n.lastApplied,
+		SnapshotIndex: n.snapIndex,
+		Peers:         make([]PeerStatus, 0, len(n.peers)),
+	}
+	for id, url := range n.peers {
+		p := PeerStatus{ID: id, URL: url}
+		if n.role == RoleLeader {
+			p.MatchIndex, p.LastContact = n.matchIndex[id], n.lastContact[id]
+			if id == n.opts.ID {
+				p.MatchIndex = status.LastIndex
+			}
+		}
+		status.Peers = append(status.Peers, p)
+	}
+	sort.Slice(status.Peers, func(i, j int) bool { return status.Peers[i].ID < status.Peers[j].ID })
+	return status
+}
+
+// Propose appends a command to the log and waits until it is applied,
+// returning its log index. Only the leader accepts proposals; use Submit
+// to forward them from any node.
+func (n *Node) Propose(ctx context.Context, command []byte) (uint64, error) {
+	result := make(chan error, 1)
+	entry, err := n.append(command, result)
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case err := <-result:
+		return entry.Index, err
+	case <-ctx.Done():
+		n.mu.Lock()
+		delete(n.waiters, entry.Index)
+		n.mu.Unlock()
+		return 0, ctx.Err()
+	case <-n.stopch:
+		return 0, ErrStopped
+	}
+}
+
+// Append appends a command to the log like Propose, but returns its index
+// without waiting for it to be applied. The state machine learns whether
+// it committed.
+func (n *Node) Append(command []byte) (uint64, error) {
+	entry, err := n.append(command, nil)
+	return entry.Index, err
+}
+
+// append appends a command to the leader's log and starts replicating it;
+// result, when set, receives the outcome once the entry is applied
+func (n *Node) append(command []byte, result chan error) (Entry, error) {
+	n.mu.Lock()
+	if n.role != RoleLeader {
+		n.mu.Unlock()
+		return Entry{}, ErrNotLeader
+	}
+	entry := Entry{Index: n.lastIndexLocked() + 1, Term: n.term, Command: command}
+	n.log = append(n.log, entry)
+	if err := n.persistLogLocked(); err != nil {
+		n.log = n.log[:len(n.log)-1]
+		n.mu.Unlock()
+		return Entry{}, err
+	}
+	if result != nil {
+		n.waiters[entry.Index] = waiter{term: entry.Term, result: result}
+	}
+	n.advanceCommitLocked()
+	n.mu.Unlock()
+
+	n.runtime.Go(n.replicateAll)
+	return entry, nil
+}
+
+// Submit proposes a command on the leader, forwarding it when this node is
+// a follower
+func (n *Node) Submit(ctx context.Context, command []byte) (uint64, error) {
+	index, err := n.Propose(ctx, command)
+	if !errors.Is(err, ErrNotLeader) {
+		return index, err
+	}
+	n.mu.Lock()
+	url := n.peers[n.leader]
+	n.mu.Unlock()
+	if url == "" {
+		return 0, ErrNoLeader
+	}
+	return n.transport.Propose(ctx, url, command)
+}
+
+// campaign starts an election for the next term
+func (n *Node) campaign() {
+	n.mu.Lock()
+	n.role = RoleCandidate
+	n.term++
+	n.votedFor = n.opts.ID
+	n.leader = ""
+	n.resetElectionTimerLocked()
+	if err := n.persistStateLocked(); err != nil {
+		slog.Error("failed to persist raft state", "error", err)
+		n.mu.Unlock()
+		return
+	}
+	term := n.term
+	req := VoteRequest{Term: term, CandidateID: n.opts.ID, LastLogIndex: n.lastIndexLocked(), LastLogTerm: n.lastTermLocked()}
+	slog.Debug("starting raft election", "term", term)
+
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeaderLocked()
+		n.mu.Unlock()
+		return
+	}
+	n.mu.Unlock()
+
+	for _, id := range n.others {
+		url := n.peers[id]
+		n.runtime.Go(func() {
+			resp, err := n.transport.RequestVote(url, req)
+			if err != nil {
+				return
+			}
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if resp.Term > n.term {
+				n.stepDownLocked(resp.Term, "")
+				return
+			}
+			if n.role != RoleCandidate || n.term != term || !resp.VoteGranted {
+				return
+			}
+			votes++
+			if votes >= n.quorum() {
+				n.becomeLeaderLocked()
+			}
+		})
+	}
+}
+
+// becomeLeaderLocked takes over as leader and appends an empty entry, so
+// entries left over from earlier terms commit with it
+func (n *Node) becomeLeaderLocked() {
+	n.role = RoleLeader
+	n.leader = n.opts.ID
+	n.nextIndex = make(map[string]uint64, len(n.peers))
+	n.matchIndex = make(map[string]uint64, len(n.peers))
+	n.lastContact = make(map[string]time.Time, len(n.peers))
+	n.inflight = make(map[string]bool, len(n.peers))
+	for id := range n.peers {
+		n.nextIndex[id] = n.lastIndexLocked() + 1
+	}
+	n.log = append(n.log, Entry{Index: n.lastIndexLocked() + 1, Term: n.term})
+	if err := n.persistLogLocked(); err != nil {
+		slog.Error("failed to persist raft log", "error", err)
+	}
+	slog.Info("elected raft leader", "id", n.opts.ID, "term", n.term)
+	n.advanceCommitLocked()
+	n.runtime.Go(n.replicateAll)
+}
+
+// stepDownLocked follows the leader of the given term, failing the
+// proposals this node was waiting for if it was the leader
+func (n *Node) stepDownLocked(term uint64, leader string) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		if err := n.persistStateLocked(); err != nil {
+			slog.Error("failed to persist raft state", "error", err)
+		}
+	}
+	if n.role == RoleLeader {
+		slog.Info("stepping down as raft leader", "id", n.opts.ID, "term", n.term)
+		for index, w := range n.waiters {
+			w.result <- ErrLeadershipLost
+			delete(n.waiters, index)
+		}
+	}
+	n.role = RoleFollower
+	if leader != "" && leader != n.leader {
+		slog.Info("following raft leader", "leader", leader, "term", n.term)
+	}
+	n.leader = leader
+	n.resetElectionTimerLocked()
+}
+
+func (n *Node) resetElectionTimerLocked() {
+	timeout := n.opts.ElectionTimeout + time.Duration(n.runtime.Int64N(int64(n.opts.ElectionTimeout)))
+	n.deadline = n.runtime.Now().Add(timeout)
+}
+
+func (n *Node) quorum() int { return len(n.peers)/2 + 1 }
+
+// replicateAll brings every follower up to date
+func (n *Node) replicateAll() {
+	for _, id := range n.others {
+		n.runtime.Go(func() { n.replicate(id) })
+	}
+}
+
+// replicate sends a follower the entries it lacks, or the snapshot when
+// they were compacted, until it has caught up. One request per follower
+// is in flight at a time.
+func (n *Node) replicate(id string) {
+	n.mu.Lock()
+	if n.role != RoleLeader || n.inflight[id] {
+		n.mu.Unlock()
+		return
+	}
+	n.inflight[id] = true
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		if n.inflight != nil {
+			delete(n.inflight, id)
+		}
+		n.mu.Unlock()
+	}()
+
+	for {
+		n.mu.Lock()
+		if n.role != RoleLeader {
+			n.mu.Unlock()
+			return
+		}
+		term, next := n.term, n.nextIndex[id]
+		var snap *SnapshotRequest
+		var req AppendRequest
+		if next <= n.snapIndex {
+			snap = &SnapshotRequest{Term: term, LeaderID: n.opts.ID, Index: n.snapIndex, LastTerm: n.snapTerm, Data: n.snapshot}
+		} else {
+			req = AppendRequest{
+				Term:         term,
+				LeaderID:     n.opts.ID,
+				PrevLogIndex: next - 1,
+				PrevLogTerm:  n.termAtLocked(next - 1),
+				LeaderCommit: n.commitIndex,
+			}
+			from := int(next - n.snapIndex - 1)
+			to := min(len(n.log), from+maxAppendEntries)
+			req.Entries = append([]Entry(nil), n.log[from:to]...)
+		}
+		n.mu.Unlock()
+
+		var respTerm uint64
+		var success bool
+		var conflict uint64
+		if snap != nil {
+			resp, err := n.transport.InstallSnapshot(n.peers[id], *snap)
+			if err != nil {
+				return
+			}
+			respTerm, success = resp.Term, true
+		} else {
+			resp, err := n.transport.AppendEntries(n.peers[id], req)
+			if err != nil {
+				return
+			}
+			respTerm, success, conflict = resp.Term, resp.Success, resp.ConflictIndex
+		}
+
+		n.mu.Lock()
+		if respTerm > n.term {
+			n.stepDownLocked(respTerm, "")
+			n.mu.Unlock()
+			return
+		}
+		if n.role != RoleLeader || n.term != term {
+			n.mu.Unlock()
+			return
+		}
+		n.lastContact[id] = n.runtime.Now()
+		switch {
+		case snap != nil:
+			n.matchIndex[id] = max(n.matchIndex[id], snap.Index)
+			n.nextIndex[id] = n.matchIndex[id] + 1
+		case success:
+			n.matchIndex[id] = max(n.matchIndex[id], req.PrevLogIndex+uint64(len(req.Entries)))
+			n.nextIndex[id] = n.matchIndex[id] + 1
+			n.advanceCommitLocked()
+		default:
+			// Skip back to where the follower's log diverges
+			next := next - 1
+			if conflict > 0 {
+				next = min(next, conflict)
+			}
+			n.nextIndex[id] = max(1, next)
+		}
+		caughtUp := n.nextIndex[id] > n.lastIndexLocked()
+		n.mu.Unlock()
+		if caughtUp {
+			return
+		}
+	}
+}
+
+// advanceCommitLocked commits the entries of the current term stored by a
+// majority, and the entries before them
+func (n *Node) advanceCommitLocked() {
+	for index := n.lastIndexLocked(); index > n.commitIndex; index-- {
+		if n.termAtLocked(index) != n.term {
+			break
+		}
+		count := 1
+		for id, match := range n.matchIndex {
+			if id != n.opts.ID && match >= index {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.commitIndex = index
+			n.applyLocked()
+			return
+		}
+	}
+}
+
+// applyLocked applies the committed entries not applied yet and compacts
+// the log once enough were applied
+func (n *Node) applyLocked() {
+	for n.lastApplied < n.commitIndex {
+		n.lastApplied++
+		entry := n.entryLocked(n.lastApplied)
+		var err error
+		if len(entry.Command) > 0 {
+			err = n.fsm.Apply(entry.Index, entry.Command)
+		}
+		if w, ok := n.waiters[entry.Index]; ok {
+			if w.term != entry.Term {
+				err = ErrLeadershipLost
+			}
+			w.result <- err
+			delete(n.waiters, entry.Index)
+		}
+	}
+	if n.lastApplied-n.snapIndex >= n.opts.SnapshotThreshold {
+		if err := n.compactLocked(); err != nil {
+			slog.Error("failed to compact raft log", "error", err)
+		}
+	}
+}
+
+// compactLocked replaces the applied entries with a snapshot
+func (n *Node) compactLocked() error {
+	data, err := n.fsm.Snapshot()
+	if err != nil {
+		return err
+	}
+	term := n.termAtLocked(n.lastApplied)
+	n.log = append([]Entry(nil), n.log[n.lastApplied-n.snapIndex:]...)
+	n.snapIndex, n.snapTerm, n.snapshot = n.lastApplied, term, data
+	if err := n.persistSnapshotLocked(); err != nil {
+		return err
+	}
+	return n.persistLogLocked()
+}
+
+func (n *Node) lastIndexLocked() uint64 {
+	return n.snapIndex + uint64(len(n.log))
+}
+
+func (n *Node) lastTermLocked() uint64 {
+	return n.termAtLocked(n.lastIndexLocked())
+}
+
+// termAtLocked returns the term of the entry at index, which must not be
+// before the snapshot
+func (n *Node) termAtLocked(index uint64) uint64 {
+	if index == n.snapIndex {
+		return n.snapTerm
+	}
+	return n.entryLocked(index).Term
+}
+
+func (n *Node) entryLocked(index uint64) Entry {
+	return n.log[index-n.snapIndex-1]
+}
+
+// HandleVote answers a candidate asking for this node's vote. The vote
+// goes to the first candidate of a term whose log is at least as up to
+// date as this node's.
+func (n *Node) HandleVote(req VoteRequest) VoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term > n.term {
+		// Only a granted vote holds back this node's own election: a
+		// candidate with a stale log campaigning again and again would
+		// otherwise keep the members that could win from ever trying
+		deadline, leader := n.deadline, n.role == RoleLeader
+		n.stepDownLocked(req.Term, "")
+		if !leader {
+			n.deadline = deadline
+		}
+	}
+	lastTerm := n.lastTermLocked()
+	upToDate := req.LastLogTerm > lastTerm || (req.LastLogTerm == lastTerm && req.LastLogIndex >= n.lastIndexLocked())
+	granted := req.Term == n.term && (n.votedFor == "" || n.votedFor == req.CandidateID) && upToDate
+	if granted {
+		n.votedFor = req.CandidateID
+		if err := n.persistStateLocked(); err != nil {
+			slog.Error("failed to persist raft state", "error", err)
+			granted = false
+		}
+		n.resetElectionTimerLocked()
+	}
+	return VoteResponse{Term: n.term, VoteGranted: granted}
+}
+
+// HandleAppend stores the entries sent by the leader after checking that
+// this node's log matches the leader's up to them
+func (n *Node) HandleAppend(req AppendRequest) AppendResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return AppendResponse{Term: n.term}
+	}
+	n.stepDownLocked(req.Term, req.LeaderID)
+
+	if req.PrevLogIndex > n.lastIndexLocked() {
+		return AppendResponse{Term: n.term, ConflictIndex: n.lastIndexLocked() + 1}
+	}
+	entries := req.Entries
+	if req.PrevLogIndex < n.snapIndex {
+		// The start of the entries is already in the snapshot
+		skip := min(uint64(len(entries)), n.snapIndex-req.PrevLogIndex)
+		entries = entries[skip:]
+	} else if term := n.termAtLocked(req.PrevLogIndex); term != req.PrevLogTerm {
+		// Skip the whole conflicting term at once
+		conflict := req.PrevLogIndex
+		for conflict > n.snapIndex+1 && n.termAtLocked(conflict-1) == term {
+			conflict--
+		}
+		return AppendResponse{Term: n.term, ConflictIndex: conflict}
+	}
+
+	changed := false
+	for i, e := range entries {
+		if e.Index <= n.lastIndexLocked() {
+			if n.termAtLocked(e.Index) == e.Term {
+				continue
+			}
+			// Entries from a stale leader are overwritten
+			n.log = n.log[:e.Index-n.snapIndex-1]
+		}
+		n.log = append(n.log, entries[i:]...)
+		changed = true
+		break
+	}
+	if changed {
+		if err := n.persistLogLocked(); err != nil {
+			slog.Error("failed to persist raft log", "error", err)
+			return AppendResponse{Term: n.term}
+		}
+	}
+
+	if last := req.PrevLogIndex + uint64(len(req.Entries)); req.LeaderCommit > n.commitIndex {
+		n.commitIndex = max(n.commitIndex, min(req.LeaderCommit, last))
+		n.applyLocked()
+	}
+	return AppendResponse{Term: n.term, Success: true}
+}
+
+// HandleSnapshot replaces the state with the leader's snapshot when this
+// node is too far behind to catch up from the log
+func (n *Node) HandleSnapshot(req SnapshotRequest) (SnapshotResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return SnapshotResponse{Term: n.term}, nil
+	}
+	n.stepDownLocked(req.Term, req.LeaderID)
+	if req.Index <= n.lastApplied {
+		return SnapshotResponse{Term: n.term}, nil
+	}
+
+	if err := n.fsm.Restore(req.Data); err != nil {
+		return SnapshotResponse{}, err
+	}
+	if req.Index < n.lastIndexLocked() && n.termAtLocked(req.Index) == req.LastTerm {
+		n.log = append([]Entry(nil), n.log[req.Index-n.snapIndex:]...)
+	} else {
+		n.log = nil
+	}
+	n.snapIndex, n.snapTerm, n.snapshot = req.Index, req.LastTerm, req.Data
+	n.commitIndex = max(n.commitIndex, req.Index)
+	n.lastApplied = req.Index
+	if err := n.persistSnapshotLocked(); err != nil {
+		return SnapshotResponse{}, err
+	}
+	if err := n.persistLogLocked(); err != nil {
+		return SnapshotResponse{}, err
+	}
+	slog.Info("installed raft snapshot", "index", req.Index, "leader", req.LeaderID)
+	return SnapshotResponse{Term: n.term}, nil
+}
+
+// Persistence. The vote and term are written before answering any
+// request, so a restarted node never votes twice in a term.
+
+type persistedState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"voted_for,omitempty"`
+}
+
+type persistedSnapshot struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Data  []byte `json:"data"`
+}
+
+func (n *Node) load() error {
+	if n.opts.Dir == "" {
+		return nil
+	}
+	var state persistedState
+	if err := readJSON(filepath.Join(n.opts.Dir, "state.json"), &state); err != nil {
+		return err
+	}
+	var snap persistedSnapshot
+	if err := readJSON(filepath.Join(n.opts.Dir, "snapshot.json"), &snap); err != nil {
+		return err
+	}
+	var log []Entry
+	if err := readJSON(filepath.Join(n.opts.Dir, "log.json"), &log); err != nil {
+		return err
+	}
+	if snap.Data != nil {
+		if err := n.fsm.Restore(snap.Data); err != nil {
+			return fmt.Errorf("consensus: failed to restore snapshot: %w", err)
+		}
+	}
+
+	n.term, n.votedFor = state.Term, state.VotedFor
+	n.snapIndex, n.snapTerm, n.snapshot = snap.Index, snap.Term, snap.Data
+	n.commitIndex, n.lastApplied = snap.Index, snap.Index
+	n.log = log
+	return nil
+}
+
+func (n *Node) persistStateLocked() error {
+	if n.opts.Dir == "" {
+		return nil
+	}
+	return writeJSON(filepath.Join(n.opts.Dir, "state.json"), persistedState{Term: n.term, VotedFor: n.votedFor})
+}
+
+func (n *Node) persistLogLocked() error {
+	if n.opts.Dir == "" {
+		return nil
+	}
+	return writeJSON(filepath.Join(n.opts.Dir, "log.json"), n.log)
+}
+
+func (n *Node) persistSnapshotLocked() error {
+	if n.opts.Dir == "" {
+		return nil
+	}
+	return writeJSON(filepath.Join(n.opts.Dir, "snapshot.json"), persistedSnapshot{Index: n.snapIndex, Term: n.snapTerm, Data: n.snapshot})
+}
+
+// readJSON decodes a file, leaving v unchanged if it does not exist
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("consensus: invalid %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// writeJSON replaces a file atomically
+func writeJSON(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	// Votes and entries must be on disk before they are acknowledged
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
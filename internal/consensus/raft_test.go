@@ -0,0 +1,285 @@
+package consensus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCluster is a Raft group whose members talk over httptest servers.
+// A member is partitioned by failing the requests it sends and receives.
+type testCluster struct {
+	t      *testing.T
+	peers  []Peer
+	nodes  map[string]*Node
+	states map[string]*ClusterState
+	mu     sync.Mutex
+	down   map[string]bool
+}
+
+func newTestCluster(t *testing.T, size int) *testCluster {
+	c := &testCluster{
+		t:      t,
+		nodes:  map[string]*Node{},
+		states: map[string]*ClusterState{},
+		down:   map[string]bool{},
+	}
+	handlers := map[string]http.Handler{}
+	for i := range size {
+		id := fmt.Sprintf("n%d", i+1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.mu.Lock()
+			down, h := c.down[id], handlers[id]
+			c.mu.Unlock()
+			if down || h == nil {
+				http.Error(w, "partitioned", http.StatusBadGateway)
+				return
+			}
+			h.ServeHTTP(w, r)
+		}))
+		t.Cleanup(server.Close)
+		c.peers = append(c.peers, Peer{ID: id, URL: server.URL})
+	}
+	for _, p := range c.peers {
+		state := NewClusterState()
+		opts := Options{
+			ID:                p.ID,
+			Peers:             c.peers,
+			AuthToken:         "secret",
+			ElectionTimeout:   150 * time.Millisecond,
+			HeartbeatInterval: 20 * time.Millisecond,
+			SnapshotThreshold: 8,
+			HTTPClient:        &http.Client{Transport: partitionable{c, p.ID}, Timeout: time.Second},
+		}
+		node, err := NewNode(opts, state)
+		require.NoError(t, err)
+		c.mu.Lock()
+		handlers[p.ID] = Handler(node, state)
+		c.mu.Unlock()
+		c.nodes[p.ID], c.states[p.ID] = node, state
+		node.Start()
+		t.Cleanup(node.Stop)
+	}
+	return c
+}
+
+// partitionable fails the requests of a member that is down
+type partitionable struct {
+	c  *testCluster
+	id string
+}
+
+func (p partitionable) RoundTrip(r *http.Request) (*http.Response, error) {
+	p.c.mu.Lock()
+	down := p.c.down[p.id]
+	p.c.mu.Unlock()
+	if down {
+		return nil, errors.New("partitioned")
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func (c *testCluster) setDown(id string, down bool) {
+	c.mu.Lock()
+	c.down[id] = down
+	c.mu.Unlock()
+}
+
+// leader waits for a single leader among the reachable members, known to
+// all of them
+func (c *testCluster) leader() *Node {
+	var leader *Node
+	require.Eventually(c.t, func() bool {
+		leader = nil
+		leaders := map[string]bool{}
+		for id, n := range c.nodes {
+			c.mu.Lock()
+			down := c.down[id]
+			c.mu.Unlock()
+			if down {
+				continue
+			}
+			status := n.Status()
+			leaders[status.Leader] = true
+			if status.Role != RoleLeader {
+				continue
+			}
+			if leader != nil {
+				return false
+			}
+			leader = n
+		}
+		return leader != nil && len(leaders) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	return leader
+}
+
+func submit(t *testing.T, n *Node, cmd Command) (uint64, error) {
+	t.Helper()
+	cmd.Time = time.Now().UTC()
+	data, err := json.Marshal(cmd)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return n.Submit(ctx, data)
+}
+
+func putNamespace(name string) Command {
+	return Command{Type: CommandPutNamespace, Namespace: &Namespace{Name: name}}
+}
+
+func TestClusterReplicatesCommands(t *testing.T) {
+	c := newTestCluster(t, 3)
+	leader := c.leader()
+
+	// Followers forward commands to the leader
+	var follower *Node
+	for _, n := range c.nodes {
+		if n != leader {
+			follower = n
+			break
+		}
+	}
+	_, err := submit(t, follower, Command{Type: CommandPutMember, Member: &Member{ID: "node-a", Addr: ":3000"}})
+	require.NoError(t, err)
+	_, err = submit(t, leader, putNamespace("tenant-a"))
+	require.NoError(t, err)
+
+	for id, state := range c.states {
+		require.Eventually(t, func() bool { return len(state.View().Namespaces) == 1 }, 2*time.Second, 10*time.Millisecond, id)
+		view := state.View()
+		require.Len(t, view.Members, 1, id)
+		assert.Equal(t, ":3000", view.Members[0].Addr)
+	}
+}
+
+func TestMinorityCannotCommit(t *testing.T) {
+	c := newTestCluster(t, 3)
+	old := c.leader()
+
+	// Cut the leader off from the others; they elect a new leader
+	for id := range c.nodes {
+		c.setDown(id, c.nodes[id] != old)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	cmd, _ := json.Marshal(putNamespace("split-brain"))
+	_, err := old.Propose(ctx, cmd)
+	assert.Error(t, err, "the isolated leader cannot reach a majority")
+
+	for id := range c.nodes {
+		c.setDown(id, c.nodes[id] == old)
+	}
+	leader := c.leader()
+	require.NotSame(t, old, leader)
+	_, err = submit(t, leader, putNamespace("majority"))
+	require.NoError(t, err)
+
+	// Once healed the old leader drops its uncommitted entry
+	c.setDown(old.opts.ID, false)
+	state := c.states[old.opts.ID]
+	require.Eventually(t, func() bool {
+		ns := state.View().Namespaces
+		return len(ns) == 1 && ns[0].Name == "majority"
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, RoleFollower, old.Status().Role)
+}
+
+func TestSnapshotCatchesUpLaggingFollower(t *testing.T) {
+	c := newTestCluster(t, 3)
+	leader := c.leader()
+
+	var lagging string
+	for id, n := range c.nodes {
+		if n != leader {
+			lagging = id
+			break
+		}
+	}
+	c.setDown(lagging, true)
+	for i := range 20 {
+		_, err := submit(t, leader, putNamespace(fmt.Sprintf("ns-%02d", i)))
+		require.NoError(t, err)
+	}
+	require.Positive(t, leader.Status().SnapshotIndex, "the log was compacted")
+
+	c.setDown(lagging, false)
+	require.Eventually(t, func() bool {
+		return len(c.states[lagging].View().Namespaces) == 20
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestRestartKeepsState(t *testing.T) {
+	dir := t.TempDir()
+	state := NewClusterState()
+	opts := Options{ID: "solo", Peers: []Peer{{ID: "solo"}}, Dir: dir, ElectionTimeout: 50 * time.Millisecond, SnapshotThreshold: 4}
+	node, err := NewNode(opts, state)
+	require.NoError(t, err)
+	node.Start()
+	require.Eventually(t, func() bool { return node.Status().Role == RoleLeader }, 2*time.Second, 5*time.Millisecond)
+	for i := range 6 {
+		_, err := submit(t, node, putNamespace(fmt.Sprintf("ns-%d", i)))
+		require.NoError(t, err)
+	}
+	term := node.Status().Term
+	node.Stop()
+
+	restored := NewClusterState()
+	node, err = NewNode(opts, restored)
+	require.NoError(t, err)
+	node.Start()
+	defer node.Stop()
+	require.Eventually(t, func() bool { return len(restored.View().Namespaces) == 6 }, 2*time.Second, 5*time.Millisecond)
+	assert.Greater(t, node.Status().Term, term)
+}
+
+func TestHandlerRequiresToken(t *testing.T) {
+	node, err := NewNode(Options{ID: "solo", Peers: []Peer{{ID: "solo"}}, AuthToken: "secret"}, NewClusterState())
+	require.NoError(t, err)
+	server := httptest.NewServer(Handler(node, NewClusterState()))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/cluster/status")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/cluster/status", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	var status Status
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	_ = resp.Body.Close()
+	assert.Equal(t, RoleFollower, status.Role)
+}
+
+func TestStaleCandidateDoesNotDelayElection(t *testing.T) {
+	opts := Options{ID: "n1", Peers: []Peer{{ID: "n1"}, {ID: "n2"}, {ID: "n3"}}, Dir: t.TempDir()}
+	node, err := NewNode(opts, NewClusterState())
+	require.NoError(t, err)
+	node.mu.Lock()
+	node.log = append(node.log, Entry{Index: 1, Term: 1})
+	node.term = 1
+	deadline := node.deadline
+	node.mu.Unlock()
+
+	resp := node.HandleVote(VoteRequest{Term: 2, CandidateID: "n2"})
+	assert.False(t, resp.VoteGranted, "the candidate's log is behind")
+	assert.Equal(t, uint64(2), resp.Term)
+	node.mu.Lock()
+	assert.Equal(t, deadline, node.deadline, "a rejected candidate must not hold back this node's election")
+	node.mu.Unlock()
+
+	resp = node.HandleVote(VoteRequest{Term: 3, CandidateID: "n3", LastLogIndex: 1, LastLogTerm: 1})
+	assert.True(t, resp.VoteGranted)
+}
@@ -0,0 +1,65 @@
+package consensus
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Runtime runs the timers and background work of a node. Nodes use the
+// wall clock and goroutines unless given another one, such as a simulated
+// runtime that runs the members of a group one step at a time in an order
+// its seed decides, so their bugs can be replayed.
+type Runtime interface {
+	// Now returns the current time
+	Now() time.Time
+	// Go runs f in the background
+	Go(f func())
+	// Every calls f every interval until stop is called; stop waits for a
+	// call in progress to return
+	Every(interval time.Duration, f func()) (stop func())
+	// Int64N returns a random number in [0, n)
+	Int64N(n int64) int64
+}
+
+// Transport carries the requests between the members of a group. Nodes
+// use HTTP unless given another one.
+type Transport interface {
+	RequestVote(url string, req VoteRequest) (VoteResponse, error)
+	AppendEntries(url string, req AppendRequest) (AppendResponse, error)
+	InstallSnapshot(url string, req SnapshotRequest) (SnapshotResponse, error)
+	// Propose forwards a command to the leader at url
+	Propose(ctx context.Context, url string, command []byte) (uint64, error)
+}
+
+// realRuntime runs nodes on the wall clock and goroutines
+type realRuntime struct{}
+
+func (realRuntime) Now() time.Time { return time.Now() }
+
+func (realRuntime) Go(f func()) { go f() }
+
+func (realRuntime) Int64N(n int64) int64 { return rand.Int64N(n) }
+
+func (realRuntime) Every(interval time.Duration, f func()) func() {
+	stopch, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopch:
+				return
+			case <-ticker.C:
+				f()
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stopch) })
+		<-done
+	}
+}
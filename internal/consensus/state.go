@@ -0,0 +1,383 @@
+package consensus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/lifecycle"
+	"github.com/Skpow1234/Peervault/internal/retention"
+)
+
+var (
+	// ErrNotFound is returned when a command removes a record that does not
+	// exist
+	ErrNotFound = errors.New("consensus: record not found")
+	// ErrLeaseHeld is returned when a lease is acquired or released by
+	// someone other than its unexpired holder
+	ErrLeaseHeld = errors.New("consensus: lease is held by another holder")
+)
+
+// CommandType identifies the change a command makes to the cluster state
+type CommandType string
+
+const (
+	CommandPutMember       CommandType = "put_member"
+	CommandRemoveMember    CommandType = "remove_member"
+	CommandPutNamespace    CommandType = "put_namespace"
+	CommandDeleteNamespace CommandType = "delete_namespace"
+	CommandPutPolicy       CommandType = "put_policy"
+	CommandDeletePolicy    CommandType = "delete_policy"
+	CommandPutLock         CommandType = "put_lock"
+	CommandDeleteLock      CommandType = "delete_lock"
+	CommandAcquireLease    CommandType = "acquire_lease"
+	CommandReleaseLease    CommandType = "release_lease"
+)
+
+// Member is a node of the cluster
+type Member struct {
+	ID       string            `json:"id"`
+	Addr     string            `json:"addr"`
+	Capacity int64             `json:"capacity,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	// UpdatedAt is when the member last registered
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Namespace is the configuration shared by the files of a tenant
+type Namespace struct {
+	Name      string            `json:"name"`
+	Config    map[string]string `json:"config,omitempty"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// Lease grants a named resource to one holder until it expires
+type Lease struct {
+	Name   string `json:"name"`
+	Holder string `json:"holder"`
+	// TTLSeconds is how long an acquisition or renewal lasts
+	TTLSeconds int64     `json:"ttl_seconds"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Token is the log index of the acquisition. It grows with every new
+	// holder, so a holder whose lease expired can be fenced off by anyone
+	// who has seen a newer token.
+	Token uint64 `json:"token"`
+}
+
+// Command is a change to the cluster state. Only the fields its type uses
+// are set.
+type Command struct {
+	Type CommandType `json:"type"`
+	// Time is when the command was submitted. Commands are applied relative
+	// to it rather than the clock of each node, so every node applies them
+	// alike.
+	Time time.Time `json:"time"`
+	// Name is the member, namespace, lock key or lease a removal applies
+	// to, or the namespace of a policy; the empty namespace is the
+	// cluster-wide policy
+	Name      string            `json:"name,omitempty"`
+	Member    *Member           `json:"member,omitempty"`
+	Namespace *Namespace        `json:"namespace,omitempty"`
+	Policy    *lifecycle.Policy `json:"policy,omitempty"`
+	Lock      *retention.Lock   `json:"lock,omitempty"`
+	Lease     *Lease            `json:"lease,omitempty"`
+}
+
+// Validate checks that a command carries what its type needs, so malformed
+// commands are rejected before they reach the log
+func (c *Command) Validate() error {
+	switch c.Type {
+	case CommandPutMember:
+		if c.Member == nil || c.Member.ID == "" {
+			return errors.New("consensus: member id is required")
+		}
+	case CommandPutNamespace:
+		if c.Namespace == nil || c.Namespace.Name == "" {
+			return errors.New("consensus: namespace name is required")
+		}
+	case CommandPutPolicy:
+		if c.Policy == nil {
+			return errors.New("consensus: policy is required")
+		}
+		return c.Policy.Validate()
+	case CommandPutLock:
+		if c.Lock == nil || c.Lock.Key == "" {
+			return errors.New("consensus: lock key is required")
+		}
+	case CommandAcquireLease:
+		if c.Lease == nil || c.Lease.Name == "" || c.Lease.Holder == "" {
+			return errors.New("consensus: lease name and holder are required")
+		}
+		if c.Lease.TTLSeconds <= 0 {
+			return errors.New("consensus: lease ttl must be positive")
+		}
+	case CommandReleaseLease:
+		if c.Lease == nil || c.Lease.Name == "" || c.Lease.Holder == "" {
+			return errors.New("consensus: lease name and holder are required")
+		}
+	case CommandRemoveMember, CommandDeleteNamespace, CommandDeleteLock:
+		if c.Name == "" {
+			return fmt.Errorf("consensus: %s requires a name", c.Type)
+		}
+	case CommandDeletePolicy:
+	default:
+		return fmt.Errorf("consensus: unknown command type %q", c.Type)
+	}
+	return nil
+}
+
+// View is a copy of the cluster state
+type View struct {
+	// Index is the log index of the last applied command
+	Index      uint64      `json:"index"`
+	Members    []Member    `json:"members"`
+	Namespaces []Namespace `json:"namespaces"`
+	// Policies are the lifecycle policies by namespace
+	Policies map[string]lifecycle.Policy `json:"policies"`
+	Locks    []retention.Lock            `json:"locks"`
+	Leases   []Lease                     `json:"leases"`
+}
+
+// ClusterState is the authoritative cluster metadata: membership,
+// namespace configuration, lifecycle policies, retention locks and leases.
+// It is only changed by applying committed commands, so every node of the
+// Raft group holds the same state.
+type ClusterState struct {
+	mu         sync.RWMutex
+	index      uint64
+	members    map[string]Member
+	namespaces map[string]Namespace
+	policies   map[string]lifecycle.Policy
+	locks      map[string]retention.Lock
+	leases     map[string]Lease
+	// applied is closed and replaced whenever a command is applied
+	applied chan struct{}
+}
+
+// NewClusterState creates an empty cluster state
+func NewClusterState() *ClusterState {
+	s := &ClusterState{applied: make(chan struct{})}
+	s.reset()
+	return s
+}
+
+func (s *ClusterState) reset() {
+	s.index = 0
+	s.members = make(map[string]Member)
+	s.namespaces = make(map[string]Namespace)
+	s.policies = make(map[string]lifecycle.Policy)
+	s.locks = make(map[string]retention.Lock)
+	s.leases = make(map[string]Lease)
+}
+
+// View returns a copy of the state, with records ordered by name
+func (s *ClusterState) View() View {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v := View{
+		Index:      s.index,
+		Members:    make([]Member, 0, len(s.members)),
+		Namespaces: make([]Namespace, 0, len(s.namespaces)),
+		Policies:   make(map[string]lifecycle.Policy, len(s.policies)),
+		Locks:      make([]retention.Lock, 0, len(s.locks)),
+		Leases:     make([]Lease, 0, len(s.leases)),
+	}
+	for _, m := range s.members {
+		v.Members = append(v.Members, m)
+	}
+	sort.Slice(v.Members, func(i, j int) bool { return v.Members[i].ID < v.Members[j].ID })
+	for _, ns := range s.namespaces {
+		v.Namespaces = append(v.Namespaces, ns)
+	}
+	sort.Slice(v.Namespaces, func(i, j int) bool { return v.Namespaces[i].Name < v.Namespaces[j].Name })
+	for name, p := range s.policies {
+		v.Policies[name] = p
+	}
+	for _, l := range s.locks {
+		v.Locks = append(v.Locks, l)
+	}
+	sort.Slice(v.Locks, func(i, j int) bool { return v.Locks[i].Key < v.Locks[j].Key })
+	for _, l := range s.leases {
+		v.Leases = append(v.Leases, l)
+	}
+	sort.Slice(v.Leases, func(i, j int) bool { return v.Leases[i].Name < v.Leases[j].Name })
+	return v
+}
+
+// Lease returns a lease that has not expired at the given time
+func (s *ClusterState) Lease(name string, now time.Time) (Lease, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	l, ok := s.leases[name]
+	if !ok || !now.Before(l.ExpiresAt) {
+		return Lease{}, false
+	}
+	return l, true
+}
+
+// WaitApplied blocks until the state has applied the command at index, so
+// a node that forwarded a command to the leader can read its outcome
+func (s *ClusterState) WaitApplied(ctx context.Context, index uint64) error {
+	for {
+		s.mu.RLock()
+		done, applied := s.index >= index, s.applied
+		s.mu.RUnlock()
+		if done {
+			return nil
+		}
+		select {
+		case <-applied:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *ClusterState) notifyLocked() {
+	close(s.applied)
+	s.applied = make(chan struct{})
+}
+
+// Apply applies a committed command
+func (s *ClusterState) Apply(index uint64, data []byte) error {
+	var cmd Command
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return fmt.Errorf("consensus: invalid command: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.notifyLocked()
+	s.index = index
+	if err := cmd.Validate(); err != nil {
+		return err
+	}
+
+	switch cmd.Type {
+	case CommandPutMember:
+		m := *cmd.Member
+		m.UpdatedAt = cmd.Time
+		s.members[m.ID] = m
+	case CommandRemoveMember:
+		if _, ok := s.members[cmd.Name]; !ok {
+			return fmt.Errorf("%w: member %s", ErrNotFound, cmd.Name)
+		}
+		delete(s.members, cmd.Name)
+	case CommandPutNamespace:
+		ns := *cmd.Namespace
+		ns.UpdatedAt = cmd.Time
+		s.namespaces[ns.Name] = ns
+	case CommandDeleteNamespace:
+		if _, ok := s.namespaces[cmd.Name]; !ok {
+			return fmt.Errorf("%w: namespace %s", ErrNotFound, cmd.Name)
+		}
+		delete(s.namespaces, cmd.Name)
+		delete(s.policies, cmd.Name)
+	case CommandPutPolicy:
+		if _, ok := s.namespaces[cmd.Name]; cmd.Name != "" && !ok {
+			return fmt.Errorf("%w: namespace %s", ErrNotFound, cmd.Name)
+		}
+		s.policies[cmd.Name] = *cmd.Policy
+	case CommandDeletePolicy:
+		if _, ok := s.policies[cmd.Name]; !ok {
+			return fmt.Errorf("%w: policy of namespace %q", ErrNotFound, cmd.Name)
+		}
+		delete(s.policies, cmd.Name)
+	case CommandPutLock:
+		return s.putLock(*cmd.Lock, cmd.Time)
+	case CommandDeleteLock:
+		lock, ok := s.locks[cmd.Name]
+		if !ok {
+			return fmt.Errorf("%w: lock %s", ErrNotFound, cmd.Name)
+		}
+		if lock.Locked(cmd.Time) {
+			return &retention.LockedError{Key: lock.Key, Op: retention.OpDelete, RetainUntil: lock.RetainUntil, LegalHold: lock.LegalHold}
+		}
+		delete(s.locks, cmd.Name)
+	case CommandAcquireLease:
+		return s.acquireLease(index, *cmd.Lease, cmd.Time)
+	case CommandReleaseLease:
+		l, ok := s.leases[cmd.Lease.Name]
+		if !ok || !cmd.Time.Before(l.ExpiresAt) {
+			return fmt.Errorf("%w: lease %s", ErrNotFound, cmd.Lease.Name)
+		}
+		if l.Holder != cmd.Lease.Holder {
+			return fmt.Errorf("%w: %s holds %s", ErrLeaseHeld, l.Holder, l.Name)
+		}
+		delete(s.leases, l.Name)
+	}
+	return nil
+}
+
+// putLock sets a retention lock. As with locks kept by a single node, a
+// retention period can be extended but never shortened.
+func (s *ClusterState) putLock(lock retention.Lock, now time.Time) error {
+	if prev, ok := s.locks[lock.Key]; ok && lock.RetainUntil.Before(prev.RetainUntil) && now.Before(prev.RetainUntil) {
+		return retention.ErrShortenRetention
+	}
+	lock.UpdatedAt = now
+	s.locks[lock.Key] = lock
+	return nil
+}
+
+// acquireLease grants or renews a lease. A renewal by the current holder
+// keeps its token; a new holder gets the index of the command.
+func (s *ClusterState) acquireLease(index uint64, req Lease, now time.Time) error {
+	l, ok := s.leases[req.Name]
+	held := ok && now.Before(l.ExpiresAt)
+	if held && l.Holder != req.Holder {
+		return fmt.Errorf("%w: %s holds %s until %s", ErrLeaseHeld, l.Holder, l.Name, l.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	token := index
+	if held {
+		token = l.Token
+	}
+	s.leases[req.Name] = Lease{
+		Name:       req.Name,
+		Holder:     req.Holder,
+		TTLSeconds: req.TTLSeconds,
+		ExpiresAt:  now.Add(time.Duration(req.TTLSeconds) * time.Second),
+		Token:      token,
+	}
+	return nil
+}
+
+// Snapshot serializes the state
+func (s *ClusterState) Snapshot() ([]byte, error) {
+	return json.Marshal(s.View())
+}
+
+// Restore replaces the state with a snapshot
+func (s *ClusterState) Restore(data []byte) error {
+	var v View
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("consensus: invalid snapshot: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.notifyLocked()
+	s.reset()
+	s.index = v.Index
+	for _, m := range v.Members {
+		s.members[m.ID] = m
+	}
+	for _, ns := range v.Namespaces {
+		s.namespaces[ns.Name] = ns
+	}
+	for name, p := range v.Policies {
+		s.policies[name] = p
+	}
+	for _, l := range v.Locks {
+		s.locks[l.Key] = l
+	}
+	for _, l := range v.Leases {
+		s.leases[l.Name] = l
+	}
+	return nil
+}
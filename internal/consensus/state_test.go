@@ -0,0 +1,95 @@
+package consensus
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/lifecycle"
+	"github.com/Skpow1234/Peervault/internal/retention"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func apply(t *testing.T, s *ClusterState, index uint64, cmd Command) error {
+	t.Helper()
+	data, err := json.Marshal(cmd)
+	require.NoError(t, err)
+	return s.Apply(index, data)
+}
+
+func TestLeases(t *testing.T) {
+	s := NewClusterState()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	acquire := func(index uint64, holder string, at time.Time) error {
+		return apply(t, s, index, Command{Type: CommandAcquireLease, Time: at, Lease: &Lease{Name: "gc", Holder: holder, TTLSeconds: 10}})
+	}
+
+	require.NoError(t, acquire(1, "a", now))
+	assert.ErrorIs(t, acquire(2, "b", now.Add(5*time.Second)), ErrLeaseHeld)
+
+	// Renewing keeps the token
+	require.NoError(t, acquire(3, "a", now.Add(5*time.Second)))
+	lease, ok := s.Lease("gc", now.Add(12*time.Second))
+	require.True(t, ok)
+	assert.Equal(t, uint64(1), lease.Token)
+
+	// Once expired another holder gets a newer token
+	require.NoError(t, acquire(4, "b", now.Add(20*time.Second)))
+	lease, _ = s.Lease("gc", now.Add(20*time.Second))
+	assert.Equal(t, "b", lease.Holder)
+	assert.Equal(t, uint64(4), lease.Token)
+
+	release := Command{Type: CommandReleaseLease, Time: now.Add(21 * time.Second), Lease: &Lease{Name: "gc", Holder: "a"}}
+	assert.ErrorIs(t, apply(t, s, 5, release), ErrLeaseHeld)
+	release.Lease.Holder = "b"
+	require.NoError(t, apply(t, s, 6, release))
+	_, ok = s.Lease("gc", now.Add(21*time.Second))
+	assert.False(t, ok)
+}
+
+func TestLocksAndPolicies(t *testing.T) {
+	s := NewClusterState()
+	now := time.Now().UTC()
+	until := now.Add(time.Hour)
+
+	require.NoError(t, apply(t, s, 1, Command{Type: CommandPutLock, Time: now, Lock: &retention.Lock{Key: "doc", RetainUntil: until}}))
+	err := apply(t, s, 2, Command{Type: CommandPutLock, Time: now, Lock: &retention.Lock{Key: "doc", RetainUntil: now.Add(time.Minute)}})
+	assert.ErrorIs(t, err, retention.ErrShortenRetention)
+	assert.ErrorIs(t, apply(t, s, 3, Command{Type: CommandDeleteLock, Time: now, Name: "doc"}), retention.ErrLocked)
+	require.NoError(t, apply(t, s, 4, Command{Type: CommandDeleteLock, Time: until, Name: "doc"}))
+
+	policy := &lifecycle.Policy{Rules: []lifecycle.Rule{{ID: "expire", ExpireAfterDays: 30}}}
+	err = apply(t, s, 5, Command{Type: CommandPutPolicy, Name: "tenant-a", Policy: policy})
+	assert.ErrorIs(t, err, ErrNotFound, "the namespace must exist")
+	require.NoError(t, apply(t, s, 6, Command{Type: CommandPutNamespace, Namespace: &Namespace{Name: "tenant-a"}}))
+	require.NoError(t, apply(t, s, 7, Command{Type: CommandPutPolicy, Name: "tenant-a", Policy: policy}))
+
+	// Snapshots carry everything over
+	data, err := s.Snapshot()
+	require.NoError(t, err)
+	restored := NewClusterState()
+	require.NoError(t, restored.Restore(data))
+	assert.Equal(t, s.View(), restored.View())
+	assert.Equal(t, uint64(7), restored.View().Index)
+
+	// Deleting the namespace drops its policy
+	require.NoError(t, apply(t, s, 8, Command{Type: CommandDeleteNamespace, Name: "tenant-a"}))
+	assert.Empty(t, s.View().Policies)
+}
+
+func TestCommandValidate(t *testing.T) {
+	assert.Error(t, (&Command{Type: "bogus"}).Validate())
+	assert.Error(t, (&Command{Type: CommandPutMember, Member: &Member{}}).Validate())
+	assert.Error(t, (&Command{Type: CommandAcquireLease, Lease: &Lease{Name: "x", Holder: "a"}}).Validate())
+	assert.NoError(t, (&Command{Type: CommandDeletePolicy}).Validate())
+}
+
+func TestParsePeers(t *testing.T) {
+	peers, err := ParsePeers("a=http://10.0.0.1:7000/, b = http://10.0.0.2:7000")
+	require.NoError(t, err)
+	assert.Equal(t, []Peer{{ID: "a", URL: "http://10.0.0.1:7000"}, {ID: "b", URL: "http://10.0.0.2:7000"}}, peers)
+
+	_, err = ParsePeers("a")
+	assert.Error(t, err)
+}
@@ -4,12 +4,15 @@ import (
 	"crypto/sha256"
 	"encoding/base32"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
 	"strings"
 
+	"github.com/mr-tron/base58"
 	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
 )
 
 // ContentID represents a content-addressed identifier
@@ -93,36 +96,118 @@ func (ca *ContentAddresser) GenerateContentIDFromReader(reader io.Reader) (*Cont
 	}, nil
 }
 
+// ErrInvalidCID is wrapped by the errors of strings that are not CIDs
+var ErrInvalidCID = errors.New("invalid CID format")
+
+const (
+	// maxCIDLength bounds the CID strings parsed; the longest digests of
+	// the hashes in use fit with room to spare
+	maxCIDLength = 256
+
+	// cidV0Length is the length of base58 sha2-256 CIDv0 strings
+	cidV0Length = 46
+)
+
+// cidCodecs names the multicodecs of the CIDs ParseCID accepts
+var cidCodecs = map[uint64]string{
+	0x55:   "raw",
+	0x70:   "dag-pb",
+	0x71:   "dag-cbor",
+	0x0129: "dag-json",
+	0x0200: "json",
+}
+
+// base32Lower is the multibase base32 encoding CIDv1 strings use by default
+var base32Lower = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
 // ValidateCID validates a CID format
 func (ca *ContentAddresser) ValidateCID(cidStr string) error {
+	_, err := ca.ParseCID(cidStr)
+	return err
+}
+
+// ParseCID parses a CID string into a CID struct. Both CIDv0, base58
+// sha2-256 multihashes starting with "Qm", and CIDv1 in base32 ("b") or
+// base58 ("z") multibase are accepted; the Hash of the CID is the hex
+// multihash, as GenerateCID sets it. CIDs come from users and peers, so
+// anything else fails with ErrInvalidCID.
+func (ca *ContentAddresser) ParseCID(cidStr string) (*CID, error) {
 	if cidStr == "" {
-		return fmt.Errorf("CID cannot be empty")
+		return nil, fmt.Errorf("CID cannot be empty")
+	}
+	if len(cidStr) > maxCIDLength {
+		return nil, fmt.Errorf("%w: %d characters long", ErrInvalidCID, len(cidStr))
 	}
 
-	// Basic CID format validation
-	if !strings.HasPrefix(cidStr, "Qm") && !strings.HasPrefix(cidStr, "bafy") {
-		return fmt.Errorf("invalid CID format")
+	if len(cidStr) == cidV0Length && strings.HasPrefix(cidStr, "Qm") {
+		mh, err := base58.Decode(cidStr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCID, err)
+		}
+		algorithm, err := decodeMultihash(mh)
+		if err != nil {
+			return nil, err
+		}
+		if algorithm != "sha2-256" {
+			return nil, fmt.Errorf("%w: CIDv0 with a %s multihash", ErrInvalidCID, algorithm)
+		}
+		return &CID{Version: 0, Codec: "dag-pb", Hash: hex.EncodeToString(mh), Algorithm: algorithm}, nil
 	}
 
-	return nil
-}
+	var data []byte
+	var err error
+	switch cidStr[0] {
+	case 'b':
+		data, err = base32Lower.DecodeString(cidStr[1:])
+	case 'z':
+		data, err = base58.Decode(cidStr[1:])
+	default:
+		return nil, fmt.Errorf("%w: unsupported multibase %q", ErrInvalidCID, cidStr[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCID, err)
+	}
 
-// ParseCID parses a CID string into a CID struct
-func (ca *ContentAddresser) ParseCID(cidStr string) (*CID, error) {
-	if err := ca.ValidateCID(cidStr); err != nil {
-		return nil, err
+	version, n, err := varint.FromUvarint(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: version: %v", ErrInvalidCID, err)
+	}
+	if version != 1 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidCID, version)
 	}
+	data = data[n:]
 
-	// Parse CID components
-	// This is a simplified parser - in a real implementation, you'd use a proper CID library
-	cid := &CID{
-		Version:   1,
-		Codec:     "raw", // Default codec
-		Hash:      cidStr,
-		Algorithm: "sha2-256",
+	codecCode, n, err := varint.FromUvarint(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: codec: %v", ErrInvalidCID, err)
+	}
+	codec, ok := cidCodecs[codecCode]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported codec %#x", ErrInvalidCID, codecCode)
 	}
+	mh := data[n:]
 
-	return cid, nil
+	algorithm, err := decodeMultihash(mh)
+	if err != nil {
+		return nil, err
+	}
+	return &CID{Version: 1, Codec: codec, Hash: hex.EncodeToString(mh), Algorithm: algorithm}, nil
+}
+
+// decodeMultihash checks that mh is a single multihash of a known hash
+// with a digest of the right length, and returns the name of the hash
+func decodeMultihash(mh []byte) (string, error) {
+	decoded, err := multihash.Decode(mh)
+	if err != nil {
+		return "", fmt.Errorf("%w: multihash: %v", ErrInvalidCID, err)
+	}
+	if decoded.Name == "" {
+		return "", fmt.Errorf("%w: unknown hash %#x", ErrInvalidCID, decoded.Code)
+	}
+	if length, ok := multihash.DefaultLengths[decoded.Code]; ok && length > 0 && length != decoded.Length {
+		return "", fmt.Errorf("%w: %s digest of %d bytes", ErrInvalidCID, decoded.Name, decoded.Length)
+	}
+	return decoded.Name, nil
 }
 
 // ContentIDToCID converts a ContentID to a CID
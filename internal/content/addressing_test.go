@@ -3,6 +3,7 @@ package content
 import (
 	"bytes"
 	"encoding/base32"
+	"encoding/hex"
 	"io"
 	"strings"
 	"testing"
@@ -229,25 +230,57 @@ func TestContentAddresser_ParseCID(t *testing.T) {
 	ca := NewContentAddresser()
 
 	tests := []struct {
-		name   string
-		cidStr string
-		hasErr bool
+		name    string
+		cidStr  string
+		version int
+		codec   string
+		hash    string
+		hasErr  bool
 	}{
 		{
-			name:   "valid CID v0",
-			cidStr: "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG",
-			hasErr: false,
+			name:    "valid CID v0",
+			cidStr:  "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG",
+			version: 0,
+			codec:   "dag-pb",
+			hash:    "12209d6c2be50f706953479ab9df2ce3edca90b68053c00b3004b7f0accbe1e8eedf",
 		},
 		{
-			name:   "valid CID v1",
-			cidStr: "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi",
-			hasErr: false,
+			name:    "valid CID v1",
+			cidStr:  "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi",
+			version: 1,
+			codec:   "dag-pb",
+			hash:    "1220c3c4733ec8affd06cf9e9ff50ffc6bcd2ec85a6170004bb709669c31de94391a",
 		},
 		{
 			name:   "invalid CID",
 			cidStr: "invalid-cid",
 			hasErr: true,
 		},
+		{
+			name:   "truncated CID v1",
+			cidStr: "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbz",
+			hasErr: true,
+		},
+		{
+			name:   "CID v1 with padding",
+			cidStr: "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi=",
+			hasErr: true,
+		},
+		{
+			name:   "CID v0 outside the base58 alphabet",
+			cidStr: "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbd0",
+			hasErr: true,
+		},
+		{
+			name:   "CID v2",
+			cidStr: "b" + base32Lower.EncodeToString([]byte{2, 0x55, 0x12, 0x20}),
+			hasErr: true,
+		},
+		{
+			name:   "too long",
+			cidStr: "b" + strings.Repeat("a", maxCIDLength),
+			hasErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -255,20 +288,33 @@ func TestContentAddresser_ParseCID(t *testing.T) {
 			cid, err := ca.ParseCID(tt.cidStr)
 
 			if tt.hasErr {
-				assert.Error(t, err)
+				assert.ErrorIs(t, err, ErrInvalidCID)
 				assert.Nil(t, cid)
 			} else {
-				assert.NoError(t, err)
-				assert.NotNil(t, cid)
-				assert.Equal(t, 1, cid.Version)
-				assert.Equal(t, "raw", cid.Codec)
+				require.NoError(t, err)
+				assert.Equal(t, tt.version, cid.Version)
+				assert.Equal(t, tt.codec, cid.Codec)
 				assert.Equal(t, "sha2-256", cid.Algorithm)
-				assert.Equal(t, tt.cidStr, cid.Hash)
+				assert.Equal(t, tt.hash, cid.Hash)
 			}
 		})
 	}
 }
 
+func TestContentAddresser_ParseCIDOfGeneratedCID(t *testing.T) {
+	ca := NewContentAddresser()
+	generated, err := ca.GenerateCID([]byte("hello"), "raw")
+	require.NoError(t, err)
+
+	mh, err := hex.DecodeString(generated.Hash)
+	require.NoError(t, err)
+	cidStr := "b" + base32Lower.EncodeToString(append([]byte{1, 0x55}, mh...))
+
+	parsed, err := ca.ParseCID(cidStr)
+	require.NoError(t, err)
+	assert.Equal(t, generated, parsed)
+}
+
 func TestContentAddresser_ContentIDToCID(t *testing.T) {
 	ca := NewContentAddresser()
 
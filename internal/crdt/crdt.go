@@ -0,0 +1,169 @@
+// Package crdt implements conflict-free replicated data types for small
+// shared documents, such as folder listings or labels. Replicas change
+// their copy of a document without coordinating and exchange whole states;
+// merging states in any order, any number of times, leaves every replica
+// with the same document.
+package crdt
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Timestamp orders writes: by time, then by node ID, so writes made in the
+// same nanosecond on different nodes still have a winner
+type Timestamp struct {
+	Time int64  `json:"t"`
+	Node string `json:"n"`
+}
+
+// After reports whether t orders after o
+func (t Timestamp) After(o Timestamp) bool {
+	if t.Time != o.Time {
+		return t.Time > o.Time
+	}
+	return t.Node > o.Node
+}
+
+func (t Timestamp) String() string {
+	return strconv.FormatInt(t.Time, 10) + "@" + t.Node
+}
+
+// Clock issues increasing timestamps for one node. Timestamps observed
+// from other nodes move it forward, so a write always orders after the
+// writes its node has seen, even when their clocks run ahead.
+type Clock struct {
+	mu   sync.Mutex
+	node string
+	last int64
+}
+
+// NewClock creates a clock for node
+func NewClock(node string) *Clock {
+	return &Clock{node: node}
+}
+
+// Now returns a timestamp after every timestamp issued or observed so far
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = max(c.last+1, time.Now().UnixNano())
+	return Timestamp{Time: c.last, Node: c.node}
+}
+
+// Observe moves the clock past t
+func (c *Clock) Observe(t Timestamp) {
+	c.mu.Lock()
+	c.last = max(c.last, t.Time)
+	c.mu.Unlock()
+}
+
+// Register is the value of one key of an LWW map. Deleted keys keep their
+// register so an older write received later does not bring them back.
+type Register struct {
+	Value   json.RawMessage `json:"value,omitempty"`
+	Deleted bool            `json:"deleted,omitempty"`
+	Stamp   Timestamp       `json:"stamp"`
+}
+
+// LWWMap is a map whose keys take the value of their latest write
+type LWWMap map[string]Register
+
+// Set writes a key unless it holds a later write
+func (m LWWMap) Set(key string, value json.RawMessage, at Timestamp) {
+	m.write(key, Register{Value: value, Stamp: at})
+}
+
+// Delete removes a key unless it holds a later write
+func (m LWWMap) Delete(key string, at Timestamp) {
+	m.write(key, Register{Deleted: true, Stamp: at})
+}
+
+func (m LWWMap) write(key string, r Register) {
+	if cur, ok := m[key]; !ok || r.Stamp.After(cur.Stamp) {
+		m[key] = r
+	}
+}
+
+// Merge takes the later write of every key of o
+func (m LWWMap) Merge(o LWWMap) {
+	for key, r := range o {
+		m.write(key, r)
+	}
+}
+
+// Values returns the keys that are not deleted
+func (m LWWMap) Values() map[string]json.RawMessage {
+	values := make(map[string]json.RawMessage, len(m))
+	for key, r := range m {
+		if !r.Deleted {
+			values[key] = r.Value
+		}
+	}
+	return values
+}
+
+// ORSet is an observed-remove set: every add is tagged, and a remove only
+// removes the tags it observed, so an add concurrent with a remove wins
+type ORSet struct {
+	// Tags are the live tags of each element, sorted
+	Tags map[string][]string `json:"tags"`
+	// Removed are the tags removed so far
+	Removed map[string]bool `json:"removed,omitempty"`
+}
+
+// NewORSet creates an empty set
+func NewORSet() *ORSet {
+	return &ORSet{Tags: make(map[string][]string), Removed: make(map[string]bool)}
+}
+
+// Add adds element with a tag that must be unique across replicas
+func (s *ORSet) Add(element, tag string) {
+	if s.Removed[tag] || slices.Contains(s.Tags[element], tag) {
+		return
+	}
+	tags := append(s.Tags[element], tag)
+	slices.Sort(tags)
+	s.Tags[element] = tags
+}
+
+// Remove removes element as far as this replica has seen it added
+func (s *ORSet) Remove(element string) {
+	for _, tag := range s.Tags[element] {
+		s.Removed[tag] = true
+	}
+	delete(s.Tags, element)
+}
+
+// Merge adds the tags of o and removes the tags o removed
+func (s *ORSet) Merge(o *ORSet) {
+	for tag := range o.Removed {
+		s.Removed[tag] = true
+	}
+	for element, tags := range o.Tags {
+		for _, tag := range tags {
+			s.Add(element, tag)
+		}
+	}
+	for element, tags := range s.Tags {
+		tags = slices.DeleteFunc(tags, func(tag string) bool { return s.Removed[tag] })
+		if len(tags) == 0 {
+			delete(s.Tags, element)
+		} else {
+			s.Tags[element] = tags
+		}
+	}
+}
+
+// Elements returns the elements in the set, sorted
+func (s *ORSet) Elements() []string {
+	elements := make([]string, 0, len(s.Tags))
+	for element := range s.Tags {
+		elements = append(elements, element)
+	}
+	slices.Sort(elements)
+	return elements
+}
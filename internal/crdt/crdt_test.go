@@ -0,0 +1,123 @@
+package crdt
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLWWMapLatestWriteWins(t *testing.T) {
+	early, late := Timestamp{Time: 1, Node: "b"}, Timestamp{Time: 2, Node: "a"}
+	a, b := LWWMap{}, LWWMap{}
+	a.Set("title", json.RawMessage(`"late"`), late)
+	b.Set("title", json.RawMessage(`"early"`), early)
+	b.Delete("gone", early)
+	a.Set("gone", json.RawMessage(`1`), Timestamp{Time: 1, Node: "a"})
+
+	a.Merge(b)
+	b.Merge(a)
+	assert.Equal(t, a, b)
+	assert.Equal(t, map[string]json.RawMessage{"title": json.RawMessage(`"late"`)}, a.Values())
+
+	// Ties are broken by node ID
+	tie := LWWMap{}
+	tie.Set("k", json.RawMessage(`"b"`), Timestamp{Time: 5, Node: "b"})
+	tie.Set("k", json.RawMessage(`"a"`), Timestamp{Time: 5, Node: "a"})
+	assert.JSONEq(t, `"b"`, string(tie.Values()["k"]))
+}
+
+func TestORSetAddWinsOverConcurrentRemove(t *testing.T) {
+	a, b := NewORSet(), NewORSet()
+	a.Add("red", "a1")
+	b.Merge(a)
+
+	// b removes red while a adds it again
+	b.Remove("red")
+	a.Add("red", "a2")
+	a.Add("blue", "a3")
+
+	a.Merge(b)
+	b.Merge(a)
+	assert.Equal(t, []string{"blue", "red"}, a.Elements())
+	assert.Equal(t, a.Elements(), b.Elements())
+
+	// A remove that observed every add removes the element everywhere
+	a.Remove("red")
+	b.Merge(a)
+	assert.Equal(t, []string{"blue"}, b.Elements())
+	b.Merge(a)
+	assert.Equal(t, []string{"blue"}, b.Elements(), "merging is idempotent")
+}
+
+func TestStoresConverge(t *testing.T) {
+	one, two := NewStore("one", ""), NewStore("two", "")
+	_, _, err := one.Apply("folder", Update{
+		Set: map[string]json.RawMessage{"owner": json.RawMessage(`{ "name": "ana" }`)},
+		Add: map[string][]string{"files": {"a.txt", "b.txt"}},
+	})
+	require.NoError(t, err)
+	_, _, err = two.Apply("folder", Update{
+		Set:    map[string]json.RawMessage{"color": json.RawMessage(`"blue"`)},
+		Add:    map[string][]string{"files": {"c.txt"}},
+		Remove: map[string][]string{"files": {"a.txt"}},
+	})
+	require.NoError(t, err)
+
+	// Merging in either order gives the same document
+	for _, pair := range [][2]*Store{{one, two}, {two, one}} {
+		state, ok := pair[1].State("folder")
+		require.True(t, ok)
+		changed, err := pair[0].Merge("folder", state)
+		require.NoError(t, err)
+		assert.True(t, changed)
+	}
+	assert.Equal(t, one.Digests(), two.Digests())
+	state, _ := one.State("folder")
+	changed, err := two.Merge("folder", state)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	view, err := two.Get("folder")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "b.txt", "c.txt"}, view.Sets["files"], "two never saw a.txt, so its remove missed it")
+	assert.JSONEq(t, `{"name":"ana"}`, string(view.Fields["owner"]))
+	assert.JSONEq(t, `"blue"`, string(view.Fields["color"]))
+
+	// Writes after a merge order after everything merged
+	view, _, err = one.Apply("folder", Update{Remove: map[string][]string{"files": {"a.txt"}}, Delete: []string{"color"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b.txt", "c.txt"}, view.Sets["files"])
+	assert.NotContains(t, view.Fields, "color")
+}
+
+func TestStoreValidation(t *testing.T) {
+	s := NewStore("one", "")
+	_, _, err := s.Apply("", Update{})
+	assert.ErrorIs(t, err, ErrInvalidName)
+	_, _, err = s.Apply("bad\nname", Update{})
+	assert.ErrorIs(t, err, ErrInvalidName)
+	_, _, err = s.Apply("doc", Update{Set: map[string]json.RawMessage{"k": json.RawMessage(`{`)}})
+	assert.Error(t, err)
+	_, _, err = s.Apply("doc", Update{Set: map[string]json.RawMessage{"k": json.RawMessage(`"` + strings.Repeat("x", MaxDocumentSize) + `"`)}})
+	assert.ErrorIs(t, err, ErrTooLarge)
+	_, err = s.Get("doc")
+	assert.ErrorIs(t, err, ErrNotFound, "rejected updates create nothing")
+	_, err = s.Merge("doc", []byte("not json"))
+	assert.Error(t, err)
+}
+
+func TestStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "documents.json")
+	s := NewStore("one", path)
+	_, _, err := s.Apply("labels", Update{Add: map[string][]string{"tags": {"urgent"}}})
+	require.NoError(t, err)
+
+	reloaded := NewStore("one", path)
+	require.NoError(t, reloaded.Load())
+	assert.Equal(t, s.Digests(), reloaded.Digests())
+	assert.Equal(t, []string{"labels"}, reloaded.Names())
+}
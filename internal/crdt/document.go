@@ -0,0 +1,175 @@
+package crdt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MaxNameLength is the longest document name
+const MaxNameLength = 256
+
+// MaxDocumentSize is the largest encoded state of a document, which peers
+// exchange in one message
+const MaxDocumentSize = 1 << 20
+
+var (
+	// ErrNotFound is returned for documents that do not exist
+	ErrNotFound = errors.New("crdt: document not found")
+	// ErrInvalidName is returned for empty, overlong or unprintable names
+	ErrInvalidName = errors.New("crdt: invalid document name")
+	// ErrTooLarge is returned for updates that grow a document past
+	// MaxDocumentSize
+	ErrTooLarge = errors.New("crdt: document too large")
+)
+
+// Document is a replicated document made of fields, which take the value
+// of their latest write, and named sets, where adds win over concurrent
+// removes
+type Document struct {
+	Fields LWWMap            `json:"fields"`
+	Sets   map[string]*ORSet `json:"sets"`
+}
+
+// NewDocument creates an empty document
+func NewDocument() *Document {
+	return &Document{Fields: make(LWWMap), Sets: make(map[string]*ORSet)}
+}
+
+// decodeDocument decodes a document state
+func decodeDocument(state []byte) (*Document, error) {
+	d := NewDocument()
+	if err := json.Unmarshal(state, d); err != nil {
+		return nil, err
+	}
+	if d.Fields == nil {
+		d.Fields = make(LWWMap)
+	}
+	if d.Sets == nil {
+		d.Sets = make(map[string]*ORSet)
+	}
+	for name, set := range d.Sets {
+		if set == nil {
+			set = NewORSet()
+			d.Sets[name] = set
+		}
+		if set.Tags == nil {
+			set.Tags = make(map[string][]string)
+		}
+		if set.Removed == nil {
+			set.Removed = make(map[string]bool)
+		}
+	}
+	return d, nil
+}
+
+// State encodes the document for merging into other replicas
+func (d *Document) State() []byte {
+	// Maps are encoded with sorted keys and tags are kept sorted, so equal
+	// documents encode the same
+	state, _ := json.Marshal(d)
+	return state
+}
+
+// Digest identifies the state of the document
+func (d *Document) Digest() string {
+	sum := sha256.Sum256(d.State())
+	return hex.EncodeToString(sum[:])
+}
+
+// Merge merges the state of o into d
+func (d *Document) Merge(o *Document) {
+	d.Fields.Merge(o.Fields)
+	for name, set := range o.Sets {
+		if _, ok := d.Sets[name]; !ok {
+			d.Sets[name] = NewORSet()
+		}
+		d.Sets[name].Merge(set)
+	}
+}
+
+// Update is a set of changes applied to a document at once
+type Update struct {
+	// Set writes fields; values are any JSON
+	Set map[string]json.RawMessage `json:"set,omitempty"`
+	// Delete deletes fields
+	Delete []string `json:"delete,omitempty"`
+	// Add adds elements to sets by set name
+	Add map[string][]string `json:"add,omitempty"`
+	// Remove removes elements from sets by set name
+	Remove map[string][]string `json:"remove,omitempty"`
+}
+
+// Validate checks that the values set are JSON
+func (u Update) Validate() error {
+	for key, value := range u.Set {
+		if !json.Valid(value) {
+			return fmt.Errorf("crdt: value of field %q is not JSON", key)
+		}
+	}
+	return nil
+}
+
+// apply applies u at time at
+func (d *Document) apply(u Update, at Timestamp) {
+	for key, value := range u.Set {
+		// Stored the way states encode it, so every replica reads it the same
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, value); err == nil {
+			value = compact.Bytes()
+		}
+		d.Fields.Set(key, value, at)
+	}
+	for _, key := range u.Delete {
+		d.Fields.Delete(key, at)
+	}
+	for name, elements := range u.Add {
+		set, ok := d.Sets[name]
+		if !ok {
+			set = NewORSet()
+			d.Sets[name] = set
+		}
+		for i, element := range elements {
+			set.Add(element, at.String()+"#"+strconv.Itoa(i))
+		}
+	}
+	for name, elements := range u.Remove {
+		if set, ok := d.Sets[name]; ok {
+			for _, element := range elements {
+				set.Remove(element)
+			}
+		}
+	}
+}
+
+// View is what a document holds
+type View struct {
+	Name   string                     `json:"name"`
+	Fields map[string]json.RawMessage `json:"fields"`
+	Sets   map[string][]string        `json:"sets"`
+}
+
+// View returns what the document holds
+func (d *Document) View(name string) View {
+	v := View{Name: name, Fields: d.Fields.Values(), Sets: make(map[string][]string, len(d.Sets))}
+	for setName, set := range d.Sets {
+		if elements := set.Elements(); len(elements) > 0 {
+			v.Sets[setName] = elements
+		}
+	}
+	return v
+}
+
+// ValidateName checks a document name
+func ValidateName(name string) error {
+	if name == "" || len(name) > MaxNameLength || strings.ContainsFunc(name, func(r rune) bool { return !unicode.IsPrint(r) }) {
+		return ErrInvalidName
+	}
+	return nil
+}
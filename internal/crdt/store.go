@@ -0,0 +1,182 @@
+package crdt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// Store holds the documents of one replica
+type Store struct {
+	mu    sync.Mutex
+	clock *Clock
+	path  string
+	docs  map[string]*Document
+}
+
+// NewStore creates the store of node, persisted to path; an empty path
+// keeps documents in memory
+func NewStore(node, path string) *Store {
+	return &Store{clock: NewClock(node), path: path, docs: make(map[string]*Document)}
+}
+
+// Names returns the names of the documents, sorted
+func (s *Store) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Sorted(maps.Keys(s.docs))
+}
+
+// Get returns what a document holds
+func (s *Store) Get(name string) (View, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.docs[name]
+	if !ok {
+		return View{}, ErrNotFound
+	}
+	return d.View(name), nil
+}
+
+// Apply applies an update to a document, creating it when it does not
+// exist, and returns what it holds and its new state
+func (s *Store) Apply(name string, u Update) (View, []byte, error) {
+	if err := ValidateName(name); err != nil {
+		return View{}, nil, err
+	}
+	if err := u.Validate(); err != nil {
+		return View{}, nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := NewDocument()
+	if cur, ok := s.docs[name]; ok {
+		// Updated on a copy so an update that is too large leaves it as
+		// it was
+		d.Merge(cur)
+	}
+	d.apply(u, s.clock.Now())
+	state := d.State()
+	if len(state) > MaxDocumentSize {
+		return View{}, nil, ErrTooLarge
+	}
+	s.docs[name] = d
+	if err := s.saveLocked(); err != nil {
+		return View{}, nil, err
+	}
+	return d.View(name), state, nil
+}
+
+// State returns the state of a document
+func (s *Store) State(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.docs[name]
+	if !ok {
+		return nil, false
+	}
+	return d.State(), true
+}
+
+// Digests returns the digest of every document by name
+func (s *Store) Digests() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	digests := make(map[string]string, len(s.docs))
+	for name, d := range s.docs {
+		digests[name] = d.Digest()
+	}
+	return digests
+}
+
+// Merge merges the state of a document received from another replica and
+// reports whether the document changed. The document is created when it
+// does not exist.
+func (s *Store) Merge(name string, state []byte) (bool, error) {
+	if err := ValidateName(name); err != nil {
+		return false, err
+	}
+	if len(state) > MaxDocumentSize {
+		return false, ErrTooLarge
+	}
+	o, err := decodeDocument(state)
+	if err != nil {
+		return false, fmt.Errorf("crdt: invalid state of %s: %w", name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.mergeLocked(name, o) {
+		return false, nil
+	}
+	return true, s.saveLocked()
+}
+
+func (s *Store) mergeLocked(name string, o *Document) bool {
+	d, ok := s.docs[name]
+	if !ok {
+		d = NewDocument()
+	}
+	before := d.Digest()
+	d.Merge(o)
+	for _, r := range d.Fields {
+		s.clock.Observe(r.Stamp)
+	}
+	if ok && d.Digest() == before {
+		return false
+	}
+	s.docs[name] = d
+	return true
+}
+
+// Load reads the persisted documents
+func (s *Store) Load() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var states map[string]json.RawMessage
+	if err := json.Unmarshal(data, &states); err != nil {
+		return fmt.Errorf("crdt: corrupt document store %s: %w", s.path, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, state := range states {
+		d, err := decodeDocument(state)
+		if err != nil {
+			return fmt.Errorf("crdt: corrupt document %s in %s: %w", name, s.path, err)
+		}
+		s.mergeLocked(name, d)
+	}
+	return nil
+}
+
+func (s *Store) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.docs)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -3,6 +3,7 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hkdf"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
@@ -10,6 +11,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -21,20 +24,58 @@ const (
 	// Key derivation constants
 	KeyDerivationSalt = "peervault-cluster-salt-v1"
 	KeyRotationPeriod = 24 * time.Hour // Rotate keys every 24 hours
+	// SubKeyLabelPrefix namespaces every HKDF info label so sub-keys never
+	// collide with keys derived by other protocols from the same secret
+	SubKeyLabelPrefix = "peervault/v1/"
+	// SubKeySize is the length of every derived sub-key (AES-256 / HMAC-SHA256)
+	SubKeySize = 32
 )
 
-// KeyManager handles encryption key generation, derivation, and rotation
+// KeyPurpose labels what a derived sub-key is used for. Each purpose yields
+// an independent key, so a compromise or misuse in one context does not
+// expose the others.
+type KeyPurpose string
+
+const (
+	PurposeHandshake        KeyPurpose = "handshake"
+	PurposeStreamEncryption KeyPurpose = "stream-encryption"
+	PurposeControlMAC       KeyPurpose = "control-mac"
+	PurposeShareLink        KeyPurpose = "share-link"
+	// PurposeStorageEncryption derives the keys of the generations after
+	// the first, which is kept for files written before rotation existed
+	PurposeStorageEncryption KeyPurpose = "storage-encryption"
+	// PurposeClusterJoin derives the key sealing the credentials handed to
+	// a node joining with a join token
+	PurposeClusterJoin KeyPurpose = "cluster-join"
+)
+
+// LinkKeys holds the sub-keys bound to a single peer link
+type LinkKeys struct {
+	StreamEncryption []byte
+	ControlMAC       []byte
+}
+
+// KeyManager handles encryption key generation, derivation, and rotation.
+// Rotation moves to the next key generation; the keys of every generation
+// are derived from the cluster key, so older files stay readable.
 type KeyManager struct {
+	mu         sync.RWMutex
 	clusterKey []byte
 	derivedKey []byte
 	keyID      string
 	createdAt  time.Time
+	generation uint32
 }
 
 // NewKeyManager creates a new key manager with proper key derivation
 func NewKeyManager() (*KeyManager, error) {
 	// Try to load cluster key from environment first
-	clusterKey := os.Getenv("PEERVAULT_CLUSTER_KEY")
+	return NewKeyManagerWithClusterKey(os.Getenv("PEERVAULT_CLUSTER_KEY"))
+}
+
+// NewKeyManagerWithClusterKey creates a key manager from a hex-encoded
+// cluster key, generating one when it is empty
+func NewKeyManagerWithClusterKey(clusterKey string) (*KeyManager, error) {
 	if clusterKey == "" {
 		// Generate a new cluster key if not provided
 		clusterKeyBytes := make([]byte, 32)
@@ -81,27 +122,73 @@ func generateKeyID(key []byte) string {
 
 // GetEncryptionKey returns the current encryption key
 func (km *KeyManager) GetEncryptionKey() []byte {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
 	return km.derivedKey
 }
 
 // GetKeyID returns the current key identifier
 func (km *KeyManager) GetKeyID() string {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
 	return km.keyID
 }
 
 // ShouldRotate checks if the key should be rotated
 func (km *KeyManager) ShouldRotate() bool {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
 	return time.Since(km.createdAt) > KeyRotationPeriod
 }
 
-// RotateKey generates a new derived key
+// RotateKey moves to the next key generation
 func (km *KeyManager) RotateKey() error {
-	// For demo simplicity, we'll just regenerate the derived key
-	// In production, you'd want to implement proper key rotation with key versioning
-	km.derivedKey = deriveKey(km.clusterKey, KeyDerivationSalt+time.Now().Format("2006-01-02"))
-	km.keyID = generateKeyID(km.derivedKey)
-	km.createdAt = time.Now()
-	return nil
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	return km.setGenerationLocked(km.generation+1, time.Now())
+}
+
+// DeriveSubKey derives a purpose-bound key from a master secret using
+// HKDF-SHA256. The info label is SubKeyLabelPrefix + purpose, followed by the
+// optional context (for example a link identifier from LinkContext).
+func DeriveSubKey(master []byte, purpose KeyPurpose, context string) ([]byte, error) {
+	if len(master) == 0 {
+		return nil, fmt.Errorf("master key is empty")
+	}
+	if purpose == "" {
+		return nil, fmt.Errorf("key purpose is required")
+	}
+	info := SubKeyLabelPrefix + string(purpose)
+	if context != "" {
+		info += "/" + context
+	}
+	return hkdf.Key(sha256.New, master, []byte(KeyDerivationSalt), info, SubKeySize)
+}
+
+// LinkContext returns a stable identifier for the link between two nodes.
+// The IDs are sorted and length-prefixed so both ends compute the same value
+// and no two distinct pairs can produce the same string.
+func LinkContext(nodeA, nodeB string) string {
+	ids := []string{nodeA, nodeB}
+	sort.Strings(ids)
+	return fmt.Sprintf("link/%d:%s/%d:%s", len(ids[0]), ids[0], len(ids[1]), ids[1])
+}
+
+// DeriveLinkKeys derives the stream encryption and control MAC keys for the
+// link between localID and remoteID
+func DeriveLinkKeys(master []byte, localID, remoteID string) (LinkKeys, error) {
+	link := LinkContext(localID, remoteID)
+
+	streamKey, err := DeriveSubKey(master, PurposeStreamEncryption, link)
+	if err != nil {
+		return LinkKeys{}, fmt.Errorf("failed to derive stream key: %w", err)
+	}
+	macKey, err := DeriveSubKey(master, PurposeControlMAC, link)
+	if err != nil {
+		return LinkKeys{}, fmt.Errorf("failed to derive control MAC key: %w", err)
+	}
+
+	return LinkKeys{StreamEncryption: streamKey, ControlMAC: macKey}, nil
 }
 
 func GenerateID() string {
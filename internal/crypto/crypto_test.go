@@ -2,7 +2,10 @@ package crypto
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -80,6 +83,66 @@ func TestKeyManager_KeyRotation(t *testing.T) {
 	assert.False(t, km.ShouldRotate()) // Should reset timer
 }
 
+func TestKeyManager_Generations(t *testing.T) {
+	clusterKey := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	km, err := NewKeyManagerWithClusterKey(clusterKey)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), km.Generation())
+
+	// Files written before rotation existed carry no header
+	var legacy bytes.Buffer
+	_, err = CopyEncrypt(km.GetEncryptionKey(), strings.NewReader("legacy"), &legacy)
+	require.NoError(t, err)
+	_, ok := BlobGeneration(legacy.Bytes())
+	assert.False(t, ok)
+
+	encrypt := func(plaintext string) []byte {
+		var buf bytes.Buffer
+		_, err := km.CopyEncrypt(strings.NewReader(plaintext), &buf)
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+	gen0 := encrypt("generation 0")
+	require.NoError(t, km.RotateKey())
+	require.NoError(t, km.RotateKey())
+	gen2 := encrypt("generation 2")
+	assert.Equal(t, uint32(2), km.Generation())
+	assert.True(t, km.IsCurrent(gen2))
+	assert.False(t, km.IsCurrent(gen0))
+
+	// A manager restored to the persisted generation reads every file
+	other, err := NewKeyManagerWithClusterKey(clusterKey)
+	require.NoError(t, err)
+	require.NoError(t, other.SetGeneration(2, time.Now()))
+	assert.Equal(t, km.GetKeyID(), other.GetKeyID())
+	for blob, want := range map[*[]byte]string{&gen0: "generation 0", &gen2: "generation 2"} {
+		var out bytes.Buffer
+		gen, _, err := other.CopyDecrypt(bytes.NewReader(*blob), &out)
+		require.NoError(t, err)
+		header, _ := BlobGeneration(*blob)
+		assert.Equal(t, header, gen)
+		assert.Equal(t, want, out.String())
+	}
+	var out bytes.Buffer
+	gen, _, err := other.CopyDecrypt(bytes.NewReader(legacy.Bytes()), &out)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), gen)
+	assert.Equal(t, "legacy", out.String())
+
+	// The header is authenticated
+	tampered := append([]byte(nil), gen2...)
+	tampered[BlobHeaderSize-1] = 1
+	_, _, err = other.CopyDecrypt(bytes.NewReader(tampered), io.Discard)
+	assert.ErrorIs(t, err, ErrUndecryptable)
+
+	// Other cluster keys read nothing
+	stranger, err := NewKeyManagerWithClusterKey(strings.Repeat("ff", 32))
+	require.NoError(t, err)
+	require.NoError(t, stranger.SetGeneration(2, time.Now()))
+	_, _, err = stranger.CopyDecrypt(bytes.NewReader(gen2), io.Discard)
+	assert.ErrorIs(t, err, ErrUndecryptable)
+}
+
 func TestDeriveKey(t *testing.T) {
 	clusterKey := []byte("test-cluster-key-32-bytes-long!")
 	salt := "test-salt"
@@ -261,3 +324,96 @@ func TestConstants(t *testing.T) {
 	assert.Equal(t, "peervault-cluster-salt-v1", KeyDerivationSalt)
 	assert.Equal(t, 24*time.Hour, KeyRotationPeriod)
 }
+
+func TestDeriveSubKey_DomainSeparation(t *testing.T) {
+	master := []byte("test-cluster-key-32-bytes-long!")
+
+	handshake, err := DeriveSubKey(master, PurposeHandshake, "")
+	require.NoError(t, err)
+	stream, err := DeriveSubKey(master, PurposeStreamEncryption, "")
+	require.NoError(t, err)
+	mac, err := DeriveSubKey(master, PurposeControlMAC, "")
+	require.NoError(t, err)
+
+	assert.Len(t, handshake, SubKeySize)
+	assert.NotEqual(t, handshake, stream)
+	assert.NotEqual(t, handshake, mac)
+	assert.NotEqual(t, stream, mac)
+
+	// No sub-key may equal the master secret or the legacy storage key
+	assert.NotEqual(t, master, handshake)
+	assert.NotEqual(t, deriveKey(master, KeyDerivationSalt), handshake)
+
+	// Derivation is deterministic
+	again, err := DeriveSubKey(master, PurposeHandshake, "")
+	require.NoError(t, err)
+	assert.Equal(t, handshake, again)
+
+	// Context changes the key for the same purpose
+	withContext, err := DeriveSubKey(master, PurposeHandshake, "ctx")
+	require.NoError(t, err)
+	assert.NotEqual(t, handshake, withContext)
+}
+
+func TestDeriveSubKey_Errors(t *testing.T) {
+	_, err := DeriveSubKey(nil, PurposeHandshake, "")
+	assert.Error(t, err)
+
+	_, err = DeriveSubKey([]byte("key"), "", "")
+	assert.Error(t, err)
+}
+
+func TestLinkContext(t *testing.T) {
+	// Both ends of a link compute the same context
+	assert.Equal(t, LinkContext("node-a", "node-b"), LinkContext("node-b", "node-a"))
+
+	// Length prefixes keep ambiguous concatenations apart
+	assert.NotEqual(t, LinkContext("a/b", "c"), LinkContext("a", "b/c"))
+}
+
+func TestDeriveLinkKeys(t *testing.T) {
+	master := []byte("test-cluster-key-32-bytes-long!")
+
+	ab, err := DeriveLinkKeys(master, "node-a", "node-b")
+	require.NoError(t, err)
+	ba, err := DeriveLinkKeys(master, "node-b", "node-a")
+	require.NoError(t, err)
+	ac, err := DeriveLinkKeys(master, "node-a", "node-c")
+	require.NoError(t, err)
+
+	// Same link, same keys regardless of direction
+	assert.Equal(t, ab, ba)
+
+	// Different links never share keys
+	assert.NotEqual(t, ab.StreamEncryption, ac.StreamEncryption)
+	assert.NotEqual(t, ab.ControlMAC, ac.ControlMAC)
+
+	// Purposes on a single link are separated
+	assert.NotEqual(t, ab.StreamEncryption, ab.ControlMAC)
+}
+
+func TestIdentityPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store", "identity.key")
+
+	first, err := LoadOrCreateIdentity(path)
+	require.NoError(t, err)
+	assert.Len(t, first.ID(), 64)
+	assert.Equal(t, IdentityID(first.PublicKey()), first.ID())
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// A restart loads the same identity
+	second, err := LoadOrCreateIdentity(path)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID(), second.ID())
+
+	message := []byte("hello")
+	assert.True(t, ed25519.Verify(second.PublicKey(), message, first.Sign(message)))
+
+	// A damaged file is reported rather than replaced
+	require.NoError(t, os.WriteFile(path, []byte("garbage"), 0o600))
+	_, err = LoadOrCreateIdentity(path)
+	assert.Error(t, err)
+}
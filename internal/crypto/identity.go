@@ -0,0 +1,103 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Identity is the long-lived key pair of a node. Its ID, the hex encoded
+// public key, is the node ID peers know the node by, so it stays the same
+// across restarts and can be verified in the handshake.
+type Identity struct {
+	privateKey ed25519.PrivateKey
+}
+
+// NewIdentity generates a new identity
+func NewIdentity() (*Identity, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity: %w", err)
+	}
+	return &Identity{privateKey: privateKey}, nil
+}
+
+// LoadOrCreateIdentity reads the identity stored at path, creating and
+// storing a new one when the file does not exist
+func LoadOrCreateIdentity(path string) (*Identity, error) {
+	identity, err := LoadIdentity(path)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return identity, err
+	}
+	if identity, err = NewIdentity(); err != nil {
+		return nil, err
+	}
+	if err := identity.Save(path); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// LoadIdentity reads the PEM encoded PKCS #8 key stored at path
+func LoadIdentity(path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("identity %s holds no PEM private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identity: %w", err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("identity %s is not an Ed25519 key", path)
+	}
+	return &Identity{privateKey: privateKey}, nil
+}
+
+// Save stores the identity at path, readable by the owner only
+func (i *Identity) Save(path string) error {
+	der, err := x509.MarshalPKCS8PrivateKey(i.privateKey)
+	if err != nil {
+		return fmt.Errorf("failed to encode identity: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create identity directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return fmt.Errorf("failed to write identity: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// ID returns the node ID of the identity
+func (i *Identity) ID() string {
+	return IdentityID(i.PublicKey())
+}
+
+// PublicKey returns the public key of the identity
+func (i *Identity) PublicKey() ed25519.PublicKey {
+	return i.privateKey.Public().(ed25519.PublicKey)
+}
+
+// Sign signs message with the identity's key
+func (i *Identity) Sign(message []byte) []byte {
+	return ed25519.Sign(i.privateKey, message)
+}
+
+// IdentityID returns the node ID of a public key
+func IdentityID(publicKey ed25519.PublicKey) string {
+	return hex.EncodeToString(publicKey)
+}
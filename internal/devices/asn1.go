@@ -0,0 +1,182 @@
+package devices
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+var (
+	oidData              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidChallengePassword = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+
+	oidSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidEd25519         = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+// contentInfo and signedData are the PKCS#7 structures of a certs-only
+// message (RFC 5652), which EST returns certificates in
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	// Content is the explicitly [0] tagged content
+	Content asn1.RawValue
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []asn1.RawValue `asn1:"set"`
+	ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+	Certificates     asn1.RawValue   `asn1:"optional,tag:0"`
+	SignerInfos      []asn1.RawValue `asn1:"set"`
+}
+
+// CertsOnly encodes certificates as a PKCS#7 certs-only message
+func CertsOnly(certs ...*x509.Certificate) ([]byte, error) {
+	sd := signedData{Version: 1, DigestAlgorithms: []asn1.RawValue{}, SignerInfos: []asn1.RawValue{}}
+	sd.ContentInfo.ContentType = oidData
+	sd.Certificates = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true}
+	for _, cert := range certs {
+		sd.Certificates.Bytes = append(sd.Certificates.Bytes, cert.Raw...)
+	}
+	inner, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{ContentType: oidSignedData, Content: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: inner}})
+}
+
+// ParseCertsOnly returns the certificates of a PKCS#7 certs-only message
+func ParseCertsOnly(der []byte) ([]*x509.Certificate, error) {
+	var ci contentInfo
+	if rest, err := asn1.Unmarshal(der, &ci); err != nil || len(rest) > 0 || !ci.ContentType.Equal(oidSignedData) ||
+		ci.Content.Class != asn1.ClassContextSpecific || ci.Content.Tag != 0 {
+		return nil, errors.New("devices: not a PKCS#7 signed-data message")
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("devices: invalid PKCS#7 signed data: %w", err)
+	}
+	return x509.ParseCertificates(sd.Certificates.Bytes)
+}
+
+// tbsCertificateRequest is the signed part of a PKCS#10 request, with the
+// attributes crypto/x509 does not expose
+type tbsCertificateRequest struct {
+	Version       int
+	Subject       asn1.RawValue
+	PublicKey     asn1.RawValue
+	RawAttributes []asn1.RawValue `asn1:"tag:0"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type certificateRequest struct {
+	TBS                asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+}
+
+// ChallengePassword returns the challengePassword proving that a request
+// for pub comes from the holder of the device's secret. It is a MAC of the
+// public key, so the secret itself never travels and a request copied off
+// the network only yields a certificate for a key the copier lacks.
+func ChallengePassword(secret string, pub crypto.PublicKey) (string, error) {
+	spki, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(spki)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// NewCSR creates the DER PKCS#10 request a device enrolls with: its ID as
+// the common name and the proof of its secret as challengePassword. key
+// is an ECDSA, Ed25519 or RSA key.
+func NewCSR(key crypto.Signer, deviceID, secret string) ([]byte, error) {
+	subject, err := asn1.Marshal(pkix.Name{CommonName: deviceID}.ToRDNSequence())
+	if err != nil {
+		return nil, err
+	}
+	spki, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	password, err := ChallengePassword(secret, key.Public())
+	if err != nil {
+		return nil, err
+	}
+	value, err := asn1.Marshal(password)
+	if err != nil {
+		return nil, err
+	}
+	attr, err := asn1.Marshal(attribute{Type: oidChallengePassword, Values: []asn1.RawValue{{FullBytes: value}}})
+	if err != nil {
+		return nil, err
+	}
+	tbs, err := asn1.Marshal(tbsCertificateRequest{
+		Subject:       asn1.RawValue{FullBytes: subject},
+		PublicKey:     asn1.RawValue{FullBytes: spki},
+		RawAttributes: []asn1.RawValue{{FullBytes: attr}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var alg pkix.AlgorithmIdentifier
+	digest, opts := sha256.Sum256(tbs), crypto.Hash(crypto.SHA256)
+	signed := digest[:]
+	switch key.Public().(type) {
+	case *ecdsa.PublicKey:
+		alg.Algorithm = oidECDSAWithSHA256
+	case *rsa.PublicKey:
+		alg = pkix.AlgorithmIdentifier{Algorithm: oidSHA256WithRSA, Parameters: asn1.NullRawValue}
+	case ed25519.PublicKey:
+		alg.Algorithm, signed, opts = oidEd25519, tbs, crypto.Hash(0)
+	default:
+		return nil, fmt.Errorf("devices: unsupported key type %T", key.Public())
+	}
+	signature, err := key.Sign(rand.Reader, signed, opts)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(certificateRequest{
+		TBS:                asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: alg,
+		Signature:          asn1.BitString{Bytes: signature, BitLength: len(signature) * 8},
+	})
+}
+
+// challengePassword returns the challengePassword attribute of a request
+func challengePassword(csr *x509.CertificateRequest) string {
+	var tbs tbsCertificateRequest
+	if _, err := asn1.Unmarshal(csr.RawTBSCertificateRequest, &tbs); err != nil {
+		return ""
+	}
+	for _, raw := range tbs.RawAttributes {
+		var attr attribute
+		if _, err := asn1.Unmarshal(raw.FullBytes, &attr); err != nil || !attr.Type.Equal(oidChallengePassword) || len(attr.Values) == 0 {
+			continue
+		}
+		var password string
+		if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &password); err == nil {
+			return password
+		}
+	}
+	return ""
+}
@@ -0,0 +1,694 @@
+// Package devices is the certificate authority of the IoT devices a node
+// serves. An operator registers a device and flashes it with the secret
+// returned once; the device then enrolls for a client certificate with
+// EST (RFC 7030) over HTTP or CoAP, proving the secret, and renews it the
+// same way before it expires. MQTT and DTLS listeners trust certificates
+// of the CA that are not revoked, and know the device by its common name.
+// Revoking a device revokes all its certificates and refuses enrollment
+// until its secret is reset.
+package devices
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/audit"
+)
+
+var (
+	// ErrNotFound is returned for devices that are not registered
+	ErrNotFound = errors.New("devices: device not found")
+	// ErrExists is returned when registering a device ID twice
+	ErrExists = errors.New("devices: device already registered")
+	// ErrInvalid is returned for malformed device IDs and requests
+	ErrInvalid = errors.New("devices: invalid request")
+	// ErrDenied is returned for enrollments the CA refuses
+	ErrDenied = errors.New("devices: enrollment denied")
+	// ErrRevoked is returned when verifying a revoked certificate
+	ErrRevoked = errors.New("devices: certificate revoked")
+)
+
+const (
+	// DefaultValidity is how long device certificates are valid unless
+	// configured otherwise
+	DefaultValidity = 90 * 24 * time.Hour
+	// DefaultName is the common name of a new CA
+	DefaultName = "PeerVault Device CA"
+	// caValidity is how long a new CA is valid
+	caValidity = 10 * 365 * 24 * time.Hour
+	// crlValidity is how long a CRL is valid
+	crlValidity = 24 * time.Hour
+	// secretSize is the size of device secrets
+	secretSize = 32
+)
+
+// ESTPath is where EST is served, over HTTP and CoAP
+const ESTPath = "/.well-known/est"
+
+// File names under Options.Dir
+const (
+	caCertFile   = "ca.pem"
+	caKeyFile    = "ca-key.pem"
+	registryFile = "devices.json"
+)
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]{0,63}$`)
+
+// Options configures a CA
+type Options struct {
+	// Dir holds the CA certificate and key and the registered devices. A
+	// CA is created there on first use; an operator may put the
+	// certificate and key of an intermediate of their own PKI there
+	// instead. Empty keeps a new CA in memory.
+	Dir string
+	// Name is the common name of a new CA; empty uses DefaultName
+	Name string
+	// Validity is how long issued certificates are valid; zero uses
+	// DefaultValidity
+	Validity time.Duration
+	// Audit receives registrations, enrollments and revocations; nil uses
+	// the global audit logger
+	Audit *audit.AuditLogger
+	// Now returns the current time; nil uses time.Now
+	Now func() time.Time
+}
+
+// Certificate is a certificate issued to a device
+type Certificate struct {
+	Serial    string     `json:"serial"`
+	NotBefore time.Time  `json:"not_before"`
+	NotAfter  time.Time  `json:"not_after"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Device is a registered device; its secret is only ever returned by
+// Register and ResetSecret
+type Device struct {
+	ID string `json:"id"`
+	// Tenant is the organization of the device's certificates, which
+	// MQTT topic ACLs bind rules to
+	Tenant    string    `json:"tenant,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// RevokedAt is set while the device may not enroll
+	RevokedAt    *time.Time    `json:"revoked_at,omitempty"`
+	Certificates []Certificate `json:"certificates,omitempty"`
+}
+
+// device is a device with its secret, as kept by the CA
+type device struct {
+	Device
+	Secret string `json:"secret"`
+}
+
+// EnrollRequest asks the CA for a device certificate
+type EnrollRequest struct {
+	// CSR names the device in its common name
+	CSR *x509.CertificateRequest
+	// Secret is the device's secret when the transport authenticated it,
+	// as EST over HTTP does with Basic auth; empty checks the
+	// challengePassword of CSR, see ChallengePassword
+	Secret string
+	// Renew requires the device to hold a valid certificate, as EST's
+	// simplereenroll does
+	Renew bool
+	// Addr is the address of the device, for the audit log
+	Addr string
+}
+
+// CA issues and revokes the certificates of registered devices
+type CA struct {
+	opts Options
+	cert *x509.Certificate
+	key  crypto.Signer
+
+	mu      sync.Mutex
+	devices []device
+}
+
+// New opens the CA in opts.Dir, creating it on first use
+func New(opts Options) (*CA, error) {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Name == "" {
+		opts.Name = DefaultName
+	}
+	if opts.Validity <= 0 {
+		opts.Validity = DefaultValidity
+	}
+	ca := &CA{opts: opts}
+	if err := ca.loadCA(); err != nil {
+		return nil, err
+	}
+	if err := ca.load(); err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// Certificate returns the certificate of the CA, which devices and
+// servers trust
+func (ca *CA) Certificate() *x509.Certificate { return ca.cert }
+
+// Devices returns the registered devices, oldest first
+func (ca *CA) Devices() []Device {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	devices := make([]Device, len(ca.devices))
+	for i, d := range ca.devices {
+		devices[i] = d.clone()
+	}
+	return devices
+}
+
+// Device returns a registered device
+func (ca *CA) Device(id string) (Device, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	i := ca.indexLocked(id)
+	if i < 0 {
+		return Device{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return ca.devices[i].clone(), nil
+}
+
+// Register registers a device of a tenant, empty for none, returning the
+// secret it enrolls with. The secret is not returned again.
+func (ca *CA) Register(ctx context.Context, id, tenant, comment, actor string) (Device, string, error) {
+	if !validID.MatchString(id) {
+		return Device{}, "", fmt.Errorf("%w: device ID %q must be 1 to 64 letters, digits, '.', '_', ':' or '-'", ErrInvalid, id)
+	}
+	if tenant != "" && !validID.MatchString(tenant) {
+		return Device{}, "", fmt.Errorf("%w: tenant %q must be 1 to 64 letters, digits, '.', '_', ':' or '-'", ErrInvalid, tenant)
+	}
+	secret, err := newSecret()
+	if err != nil {
+		return Device{}, "", err
+	}
+	d := device{Device: Device{ID: id, Tenant: tenant, Comment: comment, CreatedAt: ca.opts.Now().UTC()}, Secret: secret}
+
+	ca.mu.Lock()
+	if ca.indexLocked(id) >= 0 {
+		ca.mu.Unlock()
+		return Device{}, "", fmt.Errorf("%w: %s", ErrExists, id)
+	}
+	ca.devices = append(ca.devices, d)
+	err = ca.saveLocked()
+	if err != nil {
+		ca.devices = ca.devices[:len(ca.devices)-1]
+	}
+	ca.mu.Unlock()
+	if err != nil {
+		return Device{}, "", err
+	}
+
+	ca.auditChange(ctx, "register_device", id, actor)
+	return d.clone(), secret, nil
+}
+
+// ResetSecret gives a device a new secret, as when it is reflashed, and
+// lets a revoked device enroll again. Its certificates stay as they are.
+func (ca *CA) ResetSecret(ctx context.Context, id, actor string) (Device, string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return Device{}, "", err
+	}
+	d, err := ca.update(id, func(d *device) bool {
+		d.Secret, d.RevokedAt = secret, nil
+		return true
+	})
+	if err != nil {
+		return Device{}, "", err
+	}
+	ca.auditChange(ctx, "reset_device_secret", id, actor)
+	return d, secret, nil
+}
+
+// Revoke revokes a device: its certificates are revoked and it may not
+// enroll until its secret is reset
+func (ca *CA) Revoke(ctx context.Context, id, actor string) error {
+	now := ca.opts.Now().UTC()
+	_, err := ca.update(id, func(d *device) bool {
+		if d.RevokedAt == nil {
+			d.RevokedAt = &now
+		}
+		for i := range d.Certificates {
+			if d.Certificates[i].RevokedAt == nil {
+				d.Certificates[i].RevokedAt = &now
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	ca.auditChange(ctx, "revoke_device", id, actor)
+	return nil
+}
+
+// RevokeCertificate revokes one certificate of a device, such as one whose
+// key leaked while the device keeps its identity
+func (ca *CA) RevokeCertificate(ctx context.Context, id, serial, actor string) error {
+	now := ca.opts.Now().UTC()
+	found := false
+	_, err := ca.update(id, func(d *device) bool {
+		for i, c := range d.Certificates {
+			if c.Serial == serial {
+				found = true
+				if c.RevokedAt == nil {
+					d.Certificates[i].RevokedAt = &now
+					return true
+				}
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: certificate %s of %s", ErrNotFound, serial, id)
+	}
+	ca.auditChange(ctx, "revoke_device_certificate", id+"/"+serial, actor)
+	return nil
+}
+
+// Enroll issues a certificate to the device named by the request's common
+// name, once it proved its secret
+func (ca *CA) Enroll(ctx context.Context, req EnrollRequest) (*x509.Certificate, error) {
+	cert, err := ca.enroll(req)
+	id := ""
+	if req.CSR != nil {
+		id = req.CSR.Subject.CommonName
+	}
+	if err != nil {
+		ca.auditEnroll(ctx, id, req, audit.AuditLevelWarning, "denied", fmt.Sprintf("Enrollment of device %q from %s refused: %v", id, req.Addr, err))
+		return nil, err
+	}
+	ca.auditEnroll(ctx, id, req, audit.AuditLevelInfo, "success", fmt.Sprintf("Device %s enrolled from %s, certificate %x", id, req.Addr, cert.SerialNumber))
+	return cert, nil
+}
+
+func (ca *CA) enroll(req EnrollRequest) (*x509.Certificate, error) {
+	csr := req.CSR
+	if csr == nil {
+		return nil, fmt.Errorf("%w: no certificate request", ErrInvalid)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	switch pub := csr.PublicKey.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("%w: RSA keys must have at least 2048 bits", ErrInvalid)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported key type", ErrInvalid)
+	}
+	id := csr.Subject.CommonName
+	now := ca.opts.Now()
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	i := ca.indexLocked(id)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: unknown device %q", ErrDenied, id)
+	}
+	d := &ca.devices[i]
+	if d.RevokedAt != nil {
+		return nil, fmt.Errorf("%w: device %s is revoked", ErrDenied, id)
+	}
+	if req.Secret != "" {
+		if subtle.ConstantTimeCompare([]byte(req.Secret), []byte(d.Secret)) != 1 {
+			return nil, fmt.Errorf("%w: wrong secret for %s", ErrDenied, id)
+		}
+	} else {
+		want, err := ChallengePassword(d.Secret, csr.PublicKey)
+		if err != nil || subtle.ConstantTimeCompare([]byte(challengePassword(csr)), []byte(want)) != 1 {
+			return nil, fmt.Errorf("%w: challenge password does not prove the secret of %s", ErrDenied, id)
+		}
+	}
+	if req.Renew && !slices.ContainsFunc(d.Certificates, func(c Certificate) bool { return c.RevokedAt == nil && now.Before(c.NotAfter) }) {
+		return nil, fmt.Errorf("%w: %s holds no valid certificate to renew", ErrDenied, id)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: id},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(ca.opts.Validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if d.Tenant != "" {
+		template.Subject.Organization = []string{d.Tenant}
+	}
+	if _, ok := csr.PublicKey.(*rsa.PublicKey); ok {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := d.Certificates
+	d.Certificates = append(slices.Clone(d.Certificates), Certificate{Serial: serialString(cert.SerialNumber), NotBefore: cert.NotBefore, NotAfter: cert.NotAfter})
+	if err := ca.saveLocked(); err != nil {
+		d.Certificates = previous
+		return nil, err
+	}
+	return cert, nil
+}
+
+// Verify checks that a client certificate was issued by the CA and is not
+// revoked, returning the ID of its device
+func (ca *CA) Verify(cert *x509.Certificate) (string, error) {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: ca.opts.Now(),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return "", err
+	}
+	id, serial := cert.Subject.CommonName, serialString(cert.SerialNumber)
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	i := ca.indexLocked(id)
+	if i < 0 {
+		return "", fmt.Errorf("%w: device %q is not registered", ErrRevoked, id)
+	}
+	j := slices.IndexFunc(ca.devices[i].Certificates, func(c Certificate) bool { return c.Serial == serial })
+	if j < 0 || ca.devices[i].Certificates[j].RevokedAt != nil {
+		return "", fmt.Errorf("%w: certificate %s of %s", ErrRevoked, serial, id)
+	}
+	return id, nil
+}
+
+// ClientTLS returns base requiring clients to present a device
+// certificate the CA verifies; base holds the server's own certificate
+func (ca *CA) ClientTLS(base *tls.Config) *tls.Config {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if base != nil {
+		config = base.Clone()
+	}
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	config.ClientCAs = x509.NewCertPool()
+	config.ClientCAs.AddCert(ca.cert)
+	config.VerifyPeerCertificate = func(_ [][]byte, chains [][]*x509.Certificate) error {
+		if len(chains) == 0 || len(chains[0]) == 0 {
+			return errors.New("devices: no client certificate")
+		}
+		_, err := ca.Verify(chains[0][0])
+		return err
+	}
+	return config
+}
+
+// CRL returns a DER certificate revocation list of the revoked
+// certificates that have not expired, for servers verifying device
+// certificates without asking the node, such as DTLS terminators
+func (ca *CA) CRL() ([]byte, error) {
+	now := ca.opts.Now()
+	var entries []x509.RevocationListEntry
+	ca.mu.Lock()
+	for _, d := range ca.devices {
+		for _, c := range d.Certificates {
+			if c.RevokedAt == nil || now.After(c.NotAfter) {
+				continue
+			}
+			serial, ok := new(big.Int).SetString(c.Serial, 16)
+			if !ok {
+				continue
+			}
+			entries = append(entries, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: *c.RevokedAt})
+		}
+	}
+	ca.mu.Unlock()
+	return x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(now.UnixNano()),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(crlValidity),
+		RevokedCertificateEntries: entries,
+	}, ca.cert, ca.key)
+}
+
+// update changes a device under the lock, saving when change reports a
+// change
+func (ca *CA) update(id string, change func(*device) bool) (Device, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	i := ca.indexLocked(id)
+	if i < 0 {
+		return Device{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	previous := ca.devices[i]
+	d := previous
+	d.Certificates = slices.Clone(previous.Certificates)
+	if !change(&d) {
+		return d.clone(), nil
+	}
+	ca.devices[i] = d
+	if err := ca.saveLocked(); err != nil {
+		ca.devices[i] = previous
+		return Device{}, err
+	}
+	return d.clone(), nil
+}
+
+func (ca *CA) indexLocked(id string) int {
+	return slices.IndexFunc(ca.devices, func(d device) bool { return d.ID == id })
+}
+
+func (d device) clone() Device {
+	device := d.Device
+	device.Certificates = slices.Clone(d.Certificates)
+	return device
+}
+
+func serialString(serial *big.Int) string {
+	return serial.Text(16)
+}
+
+func newSecret() (string, error) {
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// loadCA loads the CA certificate and key from opts.Dir, creating them
+// when missing
+func (ca *CA) loadCA() error {
+	if ca.opts.Dir != "" {
+		certPEM, err := os.ReadFile(filepath.Join(ca.opts.Dir, caCertFile))
+		if err == nil {
+			return ca.parseCA(certPEM)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return err
+	}
+	now := ca.opts.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: ca.opts.Name},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	if ca.cert, err = x509.ParseCertificate(der); err != nil {
+		return err
+	}
+	ca.key = key
+	if ca.opts.Dir == "" {
+		return nil
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(ca.opts.Dir, 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(ca.opts.Dir, caKeyFile), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	slog.Info("Created the device CA", "dir", ca.opts.Dir)
+	return os.WriteFile(filepath.Join(ca.opts.Dir, caCertFile), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// parseCA parses the CA certificate and reads its key
+func (ca *CA) parseCA(certPEM []byte) error {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return fmt.Errorf("devices: no certificate in %s", caCertFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("devices: invalid CA certificate: %w", err)
+	}
+	if !cert.IsCA {
+		return fmt.Errorf("devices: %s is not a CA certificate", caCertFile)
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(ca.opts.Dir, caKeyFile))
+	if err != nil {
+		return err
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return fmt.Errorf("devices: no key in %s", caKeyFile)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("devices: invalid CA key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("devices: unsupported CA key %T", key)
+	}
+	ca.cert, ca.key = cert, signer
+	return nil
+}
+
+func (ca *CA) load() error {
+	if ca.opts.Dir == "" {
+		return nil
+	}
+	path := filepath.Join(ca.opts.Dir, registryFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &ca.devices); err != nil {
+		return fmt.Errorf("devices: corrupt device registry %s: %w", path, err)
+	}
+	return nil
+}
+
+func (ca *CA) saveLocked() error {
+	if ca.opts.Dir == "" {
+		return nil
+	}
+	devices := ca.devices
+	if devices == nil {
+		devices = []device{}
+	}
+	data, err := json.MarshalIndent(devices, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(ca.opts.Dir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(ca.opts.Dir, registryFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (ca *CA) auditLogger() *audit.AuditLogger {
+	if ca.opts.Audit != nil {
+		return ca.opts.Audit
+	}
+	return audit.GlobalAuditLogger
+}
+
+// auditChange records an operator changing a device
+func (ca *CA) auditChange(ctx context.Context, action, id, actor string) {
+	logger := ca.auditLogger()
+	if logger == nil {
+		slog.Info("devices changed", "action", action, "device", id, "actor", actor)
+		return
+	}
+	if err := logger.LogAdminEvent(ctx, actor, action, "success", map[string]interface{}{"device": id}); err != nil {
+		slog.Warn("devices: failed to write audit event", "error", err)
+	}
+}
+
+// auditEnroll records a device enrolling or being refused
+func (ca *CA) auditEnroll(ctx context.Context, id string, req EnrollRequest, level audit.AuditLevel, result, message string) {
+	logger := ca.auditLogger()
+	if logger == nil {
+		slog.Info("device enrollment", "device", id, "addr", req.Addr, "renew", req.Renew, "result", result)
+		return
+	}
+	host, _, err := net.SplitHostPort(req.Addr)
+	if err != nil {
+		host = req.Addr
+	}
+	event := &audit.AuditEvent{
+		Type:      audit.AuditEventTypeSecurity,
+		Level:     level,
+		IPAddress: host,
+		Resource:  id,
+		Action:    "enroll",
+		Result:    result,
+		Message:   message,
+		Details:   map[string]interface{}{"addr": req.Addr, "device": id, "renew": req.Renew},
+		Source:    "device-ca",
+		Category:  "devices",
+		Tags:      []string{"device-enrollment", result},
+	}
+	if err := logger.LogEvent(ctx, event); err != nil {
+		slog.Warn("devices: failed to write audit event", "error", err)
+	}
+}
@@ -0,0 +1,168 @@
+package devices
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCSR(t *testing.T, id, secret string) (*x509.CertificateRequest, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := NewCSR(key, id, secret)
+	require.NoError(t, err)
+	csr, err := x509.ParseCertificateRequest(der)
+	require.NoError(t, err)
+	return csr, key
+}
+
+func TestNewCSR(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := NewCSR(key, "sensor-1", "secret")
+	require.NoError(t, err)
+	csr, err := x509.ParseCertificateRequest(der)
+	require.NoError(t, err)
+	require.NoError(t, csr.CheckSignature())
+	assert.Equal(t, "sensor-1", csr.Subject.CommonName)
+
+	want, err := ChallengePassword("secret", key.Public())
+	require.NoError(t, err)
+	assert.Equal(t, want, challengePassword(csr))
+}
+
+func TestEnroll(t *testing.T) {
+	ctx := context.Background()
+	ca, err := New(Options{Dir: t.TempDir()})
+	require.NoError(t, err)
+	_, secret, err := ca.Register(ctx, "sensor-1", "plant-a", "hall", "admin")
+	require.NoError(t, err)
+	_, _, err = ca.Register(ctx, "sensor-1", "", "", "admin")
+	assert.ErrorIs(t, err, ErrExists)
+	_, _, err = ca.Register(ctx, "bad id", "", "", "admin")
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, _, err = ca.Register(ctx, "sensor-2", "plant/a", "", "admin")
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	// Renewal needs a certificate to renew
+	csr, _ := newCSR(t, "sensor-1", secret)
+	_, err = ca.Enroll(ctx, EnrollRequest{CSR: csr, Renew: true})
+	assert.ErrorIs(t, err, ErrDenied)
+
+	// The challenge password proves the secret
+	cert, err := ca.Enroll(ctx, EnrollRequest{CSR: csr})
+	require.NoError(t, err)
+	assert.Equal(t, "sensor-1", cert.Subject.CommonName)
+	assert.Equal(t, []string{"plant-a"}, cert.Subject.Organization)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, cert.ExtKeyUsage)
+	id, err := ca.Verify(cert)
+	require.NoError(t, err)
+	assert.Equal(t, "sensor-1", id)
+
+	_, err = ca.Enroll(ctx, EnrollRequest{CSR: csr, Renew: true})
+	require.NoError(t, err)
+
+	// As does Basic auth, for HTTP
+	csr, _ = newCSR(t, "sensor-1", "")
+	_, err = ca.Enroll(ctx, EnrollRequest{CSR: csr, Secret: secret})
+	require.NoError(t, err)
+	_, err = ca.Enroll(ctx, EnrollRequest{CSR: csr})
+	assert.ErrorIs(t, err, ErrDenied)
+	_, err = ca.Enroll(ctx, EnrollRequest{CSR: csr, Secret: "wrong"})
+	assert.ErrorIs(t, err, ErrDenied)
+
+	csr, _ = newCSR(t, "sensor-2", secret)
+	_, err = ca.Enroll(ctx, EnrollRequest{CSR: csr})
+	assert.ErrorIs(t, err, ErrDenied)
+
+	d, err := ca.Device("sensor-1")
+	require.NoError(t, err)
+	assert.Len(t, d.Certificates, 3)
+}
+
+func TestRevoke(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	ca, err := New(Options{Dir: dir})
+	require.NoError(t, err)
+	_, secret, err := ca.Register(ctx, "sensor-1", "", "", "admin")
+	require.NoError(t, err)
+	csr, _ := newCSR(t, "sensor-1", secret)
+	first, err := ca.Enroll(ctx, EnrollRequest{CSR: csr})
+	require.NoError(t, err)
+	second, err := ca.Enroll(ctx, EnrollRequest{CSR: csr})
+	require.NoError(t, err)
+
+	require.NoError(t, ca.RevokeCertificate(ctx, "sensor-1", serialString(first.SerialNumber), "admin"))
+	_, err = ca.Verify(first)
+	assert.ErrorIs(t, err, ErrRevoked)
+	_, err = ca.Verify(second)
+	require.NoError(t, err)
+	assert.ErrorIs(t, ca.RevokeCertificate(ctx, "sensor-1", "ff", "admin"), ErrNotFound)
+
+	der, err := ca.CRL()
+	require.NoError(t, err)
+	crl, err := x509.ParseRevocationList(der)
+	require.NoError(t, err)
+	require.NoError(t, crl.CheckSignatureFrom(ca.Certificate()))
+	require.Len(t, crl.RevokedCertificateEntries, 1)
+	assert.Equal(t, first.SerialNumber, crl.RevokedCertificateEntries[0].SerialNumber)
+
+	// Revoking the device revokes its certificates and stops enrollment,
+	// across restarts
+	require.NoError(t, ca.Revoke(ctx, "sensor-1", "admin"))
+	ca, err = New(Options{Dir: dir})
+	require.NoError(t, err)
+	_, err = ca.Verify(second)
+	assert.ErrorIs(t, err, ErrRevoked)
+	_, err = ca.Enroll(ctx, EnrollRequest{CSR: csr})
+	assert.ErrorIs(t, err, ErrDenied)
+
+	// Until its secret is reset
+	_, secret, err = ca.ResetSecret(ctx, "sensor-1", "admin")
+	require.NoError(t, err)
+	csr, _ = newCSR(t, "sensor-1", secret)
+	_, err = ca.Enroll(ctx, EnrollRequest{CSR: csr})
+	require.NoError(t, err)
+}
+
+func TestExpiredCertificate(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	ca, err := New(Options{Validity: time.Hour, Now: func() time.Time { return now }})
+	require.NoError(t, err)
+	_, secret, err := ca.Register(ctx, "sensor-1", "", "", "admin")
+	require.NoError(t, err)
+	csr, _ := newCSR(t, "sensor-1", secret)
+	cert, err := ca.Enroll(ctx, EnrollRequest{CSR: csr})
+	require.NoError(t, err)
+
+	now = now.Add(2 * time.Hour)
+	_, err = ca.Verify(cert)
+	assert.Error(t, err)
+	_, err = ca.Enroll(ctx, EnrollRequest{CSR: csr, Renew: true})
+	assert.ErrorIs(t, err, ErrDenied)
+}
+
+func TestCertsOnly(t *testing.T) {
+	ca, err := New(Options{})
+	require.NoError(t, err)
+	der, err := CertsOnly(ca.Certificate())
+	require.NoError(t, err)
+	certs, err := ParseCertsOnly(der)
+	require.NoError(t, err)
+	require.Len(t, certs, 1)
+	assert.True(t, certs[0].Equal(ca.Certificate()))
+
+	_, err = ParseCertsOnly(ca.Certificate().Raw)
+	assert.Error(t, err)
+}
@@ -0,0 +1,99 @@
+package diagnostics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Profiles are the profile kinds Fetch knows. cpu and trace record for a
+// duration; the others are snapshots, or deltas over the duration when
+// one is given. block and mutex stay empty unless the server sets the
+// profiling rates.
+var Profiles = []string{"cpu", "heap", "allocs", "goroutine", "block", "mutex", "threadcreate", "trace"}
+
+// ProfilePath returns the path and query of a profile
+func ProfilePath(kind string, d time.Duration) (string, error) {
+	seconds := int(d.Round(time.Second).Seconds())
+	switch kind {
+	case "cpu", "trace":
+		if seconds < 1 || d > MaxProfileDuration {
+			return "", fmt.Errorf("diagnostics: a %s profile needs a duration between 1s and %s", kind, MaxProfileDuration)
+		}
+		name := "profile"
+		if kind == "trace" {
+			name = "trace"
+		}
+		return fmt.Sprintf("/debug/pprof/%s?seconds=%d", name, seconds), nil
+	}
+	known := false
+	for _, p := range Profiles {
+		known = known || p == kind
+	}
+	if !known {
+		return "", fmt.Errorf("diagnostics: unknown profile %q (want one of %s)", kind, strings.Join(Profiles, ", "))
+	}
+	if seconds > 0 {
+		return fmt.Sprintf("/debug/pprof/%s?seconds=%d", kind, seconds), nil
+	}
+	return "/debug/pprof/" + kind, nil
+}
+
+// Fetch downloads a profile from the diagnostics server at baseURL into w
+// and returns its size. A CPU profile or trace takes d to record.
+func Fetch(ctx context.Context, hc *http.Client, baseURL, token, kind string, d time.Duration, w io.Writer) (int64, error) {
+	path, err := ProfilePath(kind, d)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := get(ctx, hc, baseURL, token, path)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return n, fmt.Errorf("diagnostics: %w", err)
+	}
+	return n, nil
+}
+
+// FetchRuntime reads the runtime statistics of the server at baseURL
+func FetchRuntime(ctx context.Context, hc *http.Client, baseURL, token string) (*RuntimeStats, error) {
+	resp, err := get(ctx, hc, baseURL, token, "/debug/runtime")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var stats RuntimeStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("diagnostics: invalid runtime stats: %w", err)
+	}
+	return &stats, nil
+}
+
+func get(ctx context.Context, hc *http.Client, baseURL, token, path string) (*http.Response, error) {
+	if _, err := url.Parse(baseURL); err != nil {
+		return nil, fmt.Errorf("diagnostics: invalid URL %q", baseURL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(baseURL, "/")+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("diagnostics: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("diagnostics: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("diagnostics: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
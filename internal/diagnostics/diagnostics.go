@@ -0,0 +1,172 @@
+// Package diagnostics serves Go profiles (net/http/pprof), expvar
+// variables and runtime statistics on a dedicated port. Every request
+// needs the diagnostics bearer token: profiles reveal memory contents and
+// a CPU profile slows the server down while it runs.
+//
+// Importing net/http/pprof and expvar also registers their handlers on
+// http.DefaultServeMux; no PeerVault server serves that mux, so the
+// unauthenticated copies are never reachable.
+package diagnostics
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultAddr keeps diagnostics on the loopback interface unless a
+	// server is configured otherwise
+	DefaultAddr = "127.0.0.1:6060"
+	// TokenEnv is the environment variable holding the diagnostics token
+	// of the server binaries configured with flags
+	TokenEnv = "PEERVAULT_DIAGNOSTICS_TOKEN"
+	// MaxProfileDuration bounds CPU profiles and execution traces
+	MaxProfileDuration = 5 * time.Minute
+)
+
+// ErrNoToken is returned when diagnostics are enabled without a token
+var ErrNoToken = errors.New("diagnostics: a token is required")
+
+// Options configure a diagnostics server
+type Options struct {
+	// Addr is the listen address; empty disables diagnostics
+	Addr string
+	// Token authenticates requests as a bearer token
+	Token  string
+	Logger *slog.Logger
+}
+
+// RegisterFlags adds the -diagnostics-addr and -diagnostics-token flags to
+// fs. The token defaults to $PEERVAULT_DIAGNOSTICS_TOKEN, which keeps it
+// out of process listings.
+func RegisterFlags(fs *flag.FlagSet) *Options {
+	opts := &Options{}
+	fs.StringVar(&opts.Addr, "diagnostics-addr", "", "Listen address for pprof, expvar and runtime stats (disabled if empty)")
+	fs.StringVar(&opts.Token, "diagnostics-token", os.Getenv(TokenEnv), "Bearer token for diagnostics (default $"+TokenEnv+")")
+	return opts
+}
+
+// Handler serves the diagnostics endpoints to requests bearing token:
+//
+//	/debug/pprof/          profile index, and each profile by name
+//	/debug/pprof/profile   CPU profile, ?seconds=N
+//	/debug/pprof/trace     execution trace, ?seconds=N
+//	/debug/vars            expvar variables
+//	/debug/runtime         goroutine, memory and GC statistics as JSON
+func Handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.Handle("GET /debug/pprof/profile", limitSeconds(http.HandlerFunc(pprof.Profile)))
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+	mux.Handle("GET /debug/pprof/trace", limitSeconds(http.HandlerFunc(pprof.Trace)))
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	mux.HandleFunc("GET /debug/runtime", handleRuntime)
+	return requireToken(token, mux)
+}
+
+// requireToken rejects requests without the bearer token
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="diagnostics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitSeconds rejects profiles longer than MaxProfileDuration, which
+// would outlast the write timeout of the server
+func limitSeconds(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s := r.URL.Query().Get("seconds"); s != "" {
+			seconds, err := strconv.ParseFloat(s, 64)
+			if err != nil || seconds <= 0 || time.Duration(seconds*float64(time.Second)) > MaxProfileDuration {
+				http.Error(w, fmt.Sprintf("seconds must be between 0 and %d", int(MaxProfileDuration.Seconds())), http.StatusBadRequest)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func handleRuntime(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(ReadRuntimeStats())
+}
+
+// NewHTTPServer returns an HTTP server for Handler(token) on addr, with a
+// write timeout long enough for the longest profile
+func NewHTTPServer(addr, token string) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           Handler(token),
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      MaxProfileDuration + 30*time.Second,
+	}
+}
+
+// Server is a running diagnostics server
+type Server struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// Start listens on opts.Addr and serves diagnostics in the background. It
+// returns a nil server when opts.Addr is empty, and ErrNoToken when there
+// is no token to guard it with.
+func Start(opts Options) (*Server, error) {
+	if opts.Addr == "" {
+		return nil, nil
+	}
+	if opts.Token == "" {
+		return nil, ErrNoToken
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	listener, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("diagnostics: %w", err)
+	}
+
+	s := &Server{server: NewHTTPServer(opts.Addr, opts.Token), listener: listener}
+	go func() {
+		logger.Info("Diagnostics listening", "addr", listener.Addr().String())
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Diagnostics server stopped", "error", err)
+		}
+	}()
+	return s, nil
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Shutdown stops the server gracefully; it does nothing on a nil server
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	return s.server.Shutdown(ctx)
+}
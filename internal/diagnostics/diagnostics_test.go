@@ -0,0 +1,126 @@
+package diagnostics
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerRequiresToken(t *testing.T) {
+	server := httptest.NewServer(Handler("secret"))
+	t.Cleanup(server.Close)
+
+	for _, auth := range []string{"", "Bearer wrong", "secret", "Basic c2VjcmV0"} {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/debug/vars", nil)
+		require.NoError(t, err)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, auth)
+	}
+
+	// An empty token never lets anybody in
+	open := httptest.NewServer(Handler(""))
+	t.Cleanup(open.Close)
+	_, err := FetchRuntime(context.Background(), http.DefaultClient, open.URL, "")
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestFetch(t *testing.T) {
+	server := httptest.NewServer(Handler("secret"))
+	t.Cleanup(server.Close)
+	ctx := context.Background()
+
+	stats, err := FetchRuntime(ctx, http.DefaultClient, server.URL, "secret")
+	require.NoError(t, err)
+	assert.Positive(t, stats.Goroutines)
+	assert.Positive(t, stats.Memory.HeapAlloc)
+	assert.NotEmpty(t, stats.GoVersion)
+
+	var heap bytes.Buffer
+	n, err := Fetch(ctx, http.DefaultClient, server.URL, "secret", "heap", 0, &heap)
+	require.NoError(t, err)
+	assert.Equal(t, int64(heap.Len()), n)
+	// Profiles are gzipped protobufs
+	assert.Equal(t, []byte{0x1f, 0x8b}, heap.Bytes()[:2])
+
+	_, err = Fetch(ctx, http.DefaultClient, server.URL, "wrong", "goroutine", 0, io.Discard)
+	assert.ErrorContains(t, err, "401")
+
+	if testing.Short() {
+		t.Skip("skipping CPU profile in short mode")
+	}
+	var cpu bytes.Buffer
+	_, err = Fetch(ctx, http.DefaultClient, server.URL, "secret", "cpu", time.Second, &cpu)
+	require.NoError(t, err)
+	assert.NotZero(t, cpu.Len())
+}
+
+func TestProfileDurationIsLimited(t *testing.T) {
+	server := httptest.NewServer(Handler("secret"))
+	t.Cleanup(server.Close)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/debug/pprof/profile?seconds=3600", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestProfilePath(t *testing.T) {
+	tests := []struct {
+		kind string
+		d    time.Duration
+		want string
+	}{
+		{"cpu", 30 * time.Second, "/debug/pprof/profile?seconds=30"},
+		{"trace", 5 * time.Second, "/debug/pprof/trace?seconds=5"},
+		{"heap", 0, "/debug/pprof/heap"},
+		{"allocs", time.Minute, "/debug/pprof/allocs?seconds=60"},
+	}
+	for _, tt := range tests {
+		got, err := ProfilePath(tt.kind, tt.d)
+		require.NoError(t, err, tt.kind)
+		assert.Equal(t, tt.want, got)
+	}
+
+	for _, bad := range []struct {
+		kind string
+		d    time.Duration
+	}{{"cpu", 0}, {"cpu", time.Hour}, {"bogus", 0}} {
+		_, err := ProfilePath(bad.kind, bad.d)
+		assert.Error(t, err, bad.kind)
+	}
+}
+
+func TestStart(t *testing.T) {
+	s, err := Start(Options{})
+	assert.NoError(t, err)
+	assert.Nil(t, s, "no address disables diagnostics")
+	assert.NoError(t, s.Shutdown(context.Background()))
+
+	_, err = Start(Options{Addr: "127.0.0.1:0"})
+	assert.ErrorIs(t, err, ErrNoToken)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s, err = Start(Options{Addr: "127.0.0.1:0", Token: "secret", Logger: logger})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Shutdown(context.Background()) })
+
+	stats, err := FetchRuntime(context.Background(), http.DefaultClient, "http://"+s.Addr(), "secret")
+	require.NoError(t, err)
+	assert.Positive(t, stats.Goroutines)
+}
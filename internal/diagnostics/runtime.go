@@ -0,0 +1,89 @@
+package diagnostics
+
+import (
+	"os"
+	"runtime"
+	"time"
+)
+
+// started approximates the start of the process
+var started = time.Now()
+
+// RuntimeStats is a snapshot of the Go runtime of a server
+type RuntimeStats struct {
+	GoVersion     string  `json:"go_version"`
+	PID           int     `json:"pid"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	NumCPU        int     `json:"num_cpu"`
+	GOMAXPROCS    int     `json:"gomaxprocs"`
+	Goroutines    int     `json:"goroutines"`
+	CgoCalls      int64   `json:"cgo_calls"`
+	Memory        Memory  `json:"memory"`
+	GC            GC      `json:"gc"`
+}
+
+// Memory are heap and process memory figures in bytes
+type Memory struct {
+	HeapAlloc    uint64 `json:"heap_alloc"`
+	HeapInuse    uint64 `json:"heap_inuse"`
+	HeapIdle     uint64 `json:"heap_idle"`
+	HeapReleased uint64 `json:"heap_released"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	StackInuse   uint64 `json:"stack_inuse"`
+	Sys          uint64 `json:"sys"`
+	TotalAlloc   uint64 `json:"total_alloc"`
+	Mallocs      uint64 `json:"mallocs"`
+	Frees        uint64 `json:"frees"`
+}
+
+// GC describes garbage collection so far
+type GC struct {
+	NumGC uint32 `json:"num_gc"`
+	// NextGC is the heap size the next collection starts at
+	NextGC       uint64    `json:"next_gc"`
+	LastGC       time.Time `json:"last_gc,omitzero"`
+	PauseTotalMs float64   `json:"pause_total_ms"`
+	LastPauseMs  float64   `json:"last_pause_ms"`
+	// CPUFraction is the share of CPU time spent in GC since the start
+	CPUFraction float64 `json:"cpu_fraction"`
+}
+
+// ReadRuntimeStats takes a snapshot of the runtime. It briefly stops the
+// world to read memory statistics.
+func ReadRuntimeStats() RuntimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	stats := RuntimeStats{
+		GoVersion:     runtime.Version(),
+		PID:           os.Getpid(),
+		UptimeSeconds: time.Since(started).Seconds(),
+		NumCPU:        runtime.NumCPU(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		Goroutines:    runtime.NumGoroutine(),
+		CgoCalls:      runtime.NumCgoCall(),
+		Memory: Memory{
+			HeapAlloc:    m.HeapAlloc,
+			HeapInuse:    m.HeapInuse,
+			HeapIdle:     m.HeapIdle,
+			HeapReleased: m.HeapReleased,
+			HeapObjects:  m.HeapObjects,
+			StackInuse:   m.StackInuse,
+			Sys:          m.Sys,
+			TotalAlloc:   m.TotalAlloc,
+			Mallocs:      m.Mallocs,
+			Frees:        m.Frees,
+		},
+		GC: GC{
+			NumGC:        m.NumGC,
+			NextGC:       m.NextGC,
+			PauseTotalMs: float64(m.PauseTotalNs) / 1e6,
+			CPUFraction:  m.GCCPUFraction,
+		},
+	}
+	if m.NumGC > 0 {
+		stats.GC.LastGC = time.Unix(0, int64(m.LastGC))
+		stats.GC.LastPauseMs = float64(m.PauseNs[(m.NumGC+255)%256]) / 1e6
+	}
+	return stats
+}
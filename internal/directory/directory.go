@@ -0,0 +1,202 @@
+// Package directory presents the flat key space as a tree of folders. A
+// folder exists while keys start with its path, or while a directory object
+// (a key ending in "/") marks it.
+package directory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Separator separates the folders of a key
+const Separator = "/"
+
+// ContentType is the content type of directory objects
+const ContentType = "application/x-directory"
+
+var (
+	// ErrNotFound is returned for paths no key starts with
+	ErrNotFound = errors.New("directory: not found")
+	// ErrExists is returned when a copy or move would replace a key
+	ErrExists = errors.New("directory: destination exists")
+	// ErrNotEmpty is returned when deleting a folder that holds keys
+	// without asking for a recursive delete
+	ErrNotEmpty = errors.New("directory: not empty")
+	// ErrInvalidPath is returned for paths with empty, "." or ".."
+	// segments, and for moves of a folder into itself
+	ErrInvalidPath = errors.New("directory: invalid path")
+)
+
+// Object is a key of the store
+type Object struct {
+	Key         string    `json:"key"`
+	Name        string    `json:"name,omitempty"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+	ModTime     time.Time `json:"mod_time"`
+}
+
+// IsDir reports whether the object is a directory object
+func (o Object) IsDir() bool {
+	return strings.HasSuffix(o.Key, Separator)
+}
+
+// Store is the key space the tree is a view of. Copy and Rename return
+// ErrExists when to exists and overwrite is false; Rename must not move
+// content that stays where it is.
+type Store interface {
+	// List returns the objects whose keys start with prefix, directory
+	// objects included
+	List(ctx context.Context, prefix string) ([]Object, error)
+	Copy(ctx context.Context, from, to string, overwrite bool) error
+	Rename(ctx context.Context, from, to string, overwrite bool) error
+	Delete(ctx context.Context, key string) error
+	// Mkdir creates the directory object key, which ends in "/"
+	Mkdir(ctx context.Context, key string) error
+}
+
+// Directory is a folder of a listing
+type Directory struct {
+	// Path ends in "/"
+	Path string `json:"path"`
+	Name string `json:"name"`
+	// Explicit is set when a directory object marks the folder
+	Explicit bool `json:"explicit"`
+	// Files and Size count every file below the folder
+	Files int   `json:"files"`
+	Size  int64 `json:"size"`
+}
+
+// Listing is the content of a folder
+type Listing struct {
+	Path        string      `json:"path"`
+	Recursive   bool        `json:"recursive"`
+	Directories []Directory `json:"directories"`
+	Files       []Object    `json:"files"`
+	// TotalFiles and TotalSize count every file below the folder
+	TotalFiles int   `json:"total_files"`
+	TotalSize  int64 `json:"total_size"`
+}
+
+// Report is the outcome of a recursive copy, move or delete. Keys that
+// already existed at the destination are skipped, not failed.
+type Report struct {
+	From    string   `json:"from"`
+	To      string   `json:"to,omitempty"`
+	Files   int      `json:"files"`
+	Bytes   int64    `json:"bytes"`
+	Skipped int      `json:"skipped"`
+	Failed  int      `json:"failed"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// CleanKey normalizes a key: leading and repeated separators are dropped
+// and "." segments removed. A trailing separator is kept.
+func CleanKey(key string) (string, error) {
+	dir := strings.HasSuffix(key, Separator)
+	var segments []string
+	for _, s := range strings.Split(key, Separator) {
+		switch s {
+		case "", ".":
+			continue
+		case "..":
+			return "", fmt.Errorf("%w: %q", ErrInvalidPath, key)
+		}
+		segments = append(segments, s)
+	}
+	cleaned := strings.Join(segments, Separator)
+	if dir && cleaned != "" {
+		cleaned += Separator
+	}
+	return cleaned, nil
+}
+
+// CleanDir normalizes a folder path to end in "/"; the root is ""
+func CleanDir(dir string) (string, error) {
+	cleaned, err := CleanKey(dir)
+	if err != nil || cleaned == "" || strings.HasSuffix(cleaned, Separator) {
+		return cleaned, err
+	}
+	return cleaned + Separator, nil
+}
+
+// Parent returns the folder holding a key; the root is ""
+func Parent(key string) string {
+	i := strings.LastIndex(strings.TrimSuffix(key, Separator), Separator)
+	if i < 0 {
+		return ""
+	}
+	return key[:i+1]
+}
+
+// Base returns the last segment of a key without its separator
+func Base(key string) string {
+	return path.Base(Separator + strings.TrimSuffix(key, Separator))
+}
+
+// Build lists the folder dir from the objects below it. Without recursive
+// only its direct files and folders are listed; with it every file and
+// folder below.
+func Build(dir string, objects []Object, recursive bool) *Listing {
+	l := &Listing{Path: dir, Recursive: recursive, Directories: []Directory{}, Files: []Object{}}
+	dirs := make(map[string]*Directory)
+	addDir := func(p string) *Directory {
+		d, ok := dirs[p]
+		if !ok {
+			d = &Directory{Path: p, Name: Base(p)}
+			dirs[p] = d
+		}
+		return d
+	}
+
+	for _, o := range objects {
+		if !strings.HasPrefix(o.Key, dir) || o.Key == dir {
+			continue
+		}
+		rest := o.Key[len(dir):]
+
+		// Every folder between dir and the object, or only the first
+		var parents []string
+		for i := strings.Index(rest, Separator); i >= 0 && i < len(rest)-1; {
+			parents = append(parents, dir+rest[:i+1])
+			if !recursive {
+				break
+			}
+			next := strings.Index(rest[i+1:], Separator)
+			if next < 0 {
+				break
+			}
+			i += next + 1
+		}
+		for _, p := range parents {
+			d := addDir(p)
+			if !o.IsDir() {
+				d.Files++
+				d.Size += o.Size
+			}
+		}
+
+		switch {
+		case o.IsDir() && (recursive || len(parents) == 0):
+			addDir(o.Key).Explicit = true
+		case !o.IsDir():
+			l.TotalFiles++
+			l.TotalSize += o.Size
+			if recursive || len(parents) == 0 {
+				l.Files = append(l.Files, o)
+			}
+		}
+	}
+
+	for _, d := range dirs {
+		l.Directories = append(l.Directories, *d)
+	}
+	sort.Slice(l.Directories, func(i, j int) bool { return l.Directories[i].Path < l.Directories[j].Path })
+	sort.Slice(l.Files, func(i, j int) bool { return l.Files[i].Key < l.Files[j].Key })
+	return l
+}
@@ -0,0 +1,231 @@
+package directory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore is a Store keeping sizes only
+type memStore struct {
+	objects map[string]int64
+	// locked keys cannot be deleted or renamed
+	locked map[string]bool
+}
+
+func newMemStore(keys ...string) *memStore {
+	s := &memStore{objects: make(map[string]int64), locked: make(map[string]bool)}
+	for _, k := range keys {
+		s.objects[k] = int64(len(k))
+		if strings.HasSuffix(k, Separator) {
+			s.objects[k] = 0
+		}
+	}
+	return s
+}
+
+func (s *memStore) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	for k, size := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			objects = append(objects, Object{Key: k, Size: size})
+		}
+	}
+	return objects, nil
+}
+
+func (s *memStore) Copy(ctx context.Context, from, to string, overwrite bool) error {
+	size, ok := s.objects[from]
+	if !ok {
+		return ErrNotFound
+	}
+	if _, ok := s.objects[to]; ok && !overwrite {
+		return ErrExists
+	}
+	s.objects[to] = size
+	return nil
+}
+
+func (s *memStore) Rename(ctx context.Context, from, to string, overwrite bool) error {
+	if s.locked[from] {
+		return errors.New("locked")
+	}
+	if err := s.Copy(ctx, from, to, overwrite); err != nil {
+		return err
+	}
+	delete(s.objects, from)
+	return nil
+}
+
+func (s *memStore) Delete(ctx context.Context, key string) error {
+	if s.locked[key] {
+		return errors.New("locked")
+	}
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *memStore) Mkdir(ctx context.Context, key string) error {
+	if _, ok := s.objects[key]; ok {
+		return ErrExists
+	}
+	s.objects[key] = 0
+	return nil
+}
+
+func (s *memStore) keys() []string {
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestCleanKey(t *testing.T) {
+	for in, want := range map[string]string{
+		"":            "",
+		"/":           "",
+		"a":           "a",
+		"/a//b/":      "a/b/",
+		"./a/./b.txt": "a/b.txt",
+	} {
+		got, err := CleanKey(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := CleanKey("a/../b")
+	assert.ErrorIs(t, err, ErrInvalidPath)
+
+	dir, err := CleanDir("docs")
+	require.NoError(t, err)
+	assert.Equal(t, "docs/", dir)
+	assert.Equal(t, "docs/", Parent("docs/a.txt"))
+	assert.Equal(t, "", Parent("docs/"))
+	assert.Equal(t, "a", Base("docs/a/"))
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	tree := New(newMemStore("top.txt", "docs/a.txt", "docs/sub/b.txt", "docs/sub/deep/c.txt", "empty/"))
+
+	root, err := tree.List(ctx, "", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docs/", "empty/"}, dirPaths(root))
+	assert.True(t, root.Directories[1].Explicit)
+	assert.Equal(t, 3, root.Directories[0].Files)
+	require.Len(t, root.Files, 1)
+	assert.Equal(t, "top.txt", root.Files[0].Key)
+	assert.Equal(t, 4, root.TotalFiles)
+
+	docs, err := tree.List(ctx, "/docs", false)
+	require.NoError(t, err)
+	assert.Equal(t, "docs/", docs.Path)
+	assert.Equal(t, []string{"docs/sub/"}, dirPaths(docs))
+	assert.False(t, docs.Directories[0].Explicit)
+	assert.Len(t, docs.Files, 1)
+
+	all, err := tree.List(ctx, "docs/", true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docs/sub/", "docs/sub/deep/"}, dirPaths(all))
+	assert.Len(t, all.Files, 3)
+
+	empty, err := tree.List(ctx, "empty", false)
+	require.NoError(t, err)
+	assert.Empty(t, empty.Files)
+
+	_, err = tree.List(ctx, "missing", false)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func dirPaths(l *Listing) []string {
+	paths := make([]string, len(l.Directories))
+	for i, d := range l.Directories {
+		paths[i] = d.Path
+	}
+	return paths
+}
+
+func TestMoveFolder(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore("docs/", "docs/a.txt", "docs/sub/b.txt", "docsextra.txt", "archive/docs/a.txt")
+	tree := New(store)
+
+	report, err := tree.Move(ctx, "docs", "archive/docs", false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Files)
+	assert.Equal(t, 1, report.Skipped, "existing keys are kept")
+	assert.Equal(t, []string{"archive/docs/a.txt", "archive/docs/sub/b.txt", "docs/", "docs/a.txt", "docsextra.txt"}, store.keys(),
+		"the folder stays marked while a file remains")
+
+	report, err = tree.Move(ctx, "docs/", "archive/docs/", true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Files)
+	assert.Equal(t, []string{"archive/docs/", "archive/docs/a.txt", "archive/docs/sub/b.txt", "docsextra.txt"}, store.keys())
+
+	_, err = tree.Move(ctx, "archive", "archive/old", false)
+	assert.ErrorIs(t, err, ErrInvalidPath)
+	_, err = tree.Move(ctx, "nothing", "x", false)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestCopyFile(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore("docs/a.txt")
+	tree := New(store)
+
+	report, err := tree.Copy(ctx, "docs/a.txt", "backup/", false)
+	require.NoError(t, err)
+	assert.Equal(t, "backup/a.txt", report.To)
+	report, err = tree.Copy(ctx, "docs/a.txt", "renamed.txt", false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Files)
+	assert.Equal(t, []string{"backup/a.txt", "docs/a.txt", "renamed.txt"}, store.keys())
+
+	// A folder moved to the root loses its directory object only
+	store = newMemStore("docs/", "docs/a.txt")
+	_, err = New(store).Move(ctx, "docs", "", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "docs/"}, store.keys())
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore("empty/", "docs/", "docs/a.txt", "docs/b.txt")
+	store.locked["docs/b.txt"] = true
+	tree := New(store)
+
+	_, err := tree.Delete(ctx, "docs", false)
+	assert.ErrorIs(t, err, ErrNotEmpty)
+	_, err = tree.Delete(ctx, "empty", false)
+	require.NoError(t, err)
+
+	report, err := tree.Delete(ctx, "docs", true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Files)
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, []string{"docs/", "docs/b.txt"}, store.keys(), "the folder stays marked while a file remains")
+
+	_, err = tree.Delete(ctx, "", true)
+	assert.ErrorIs(t, err, ErrInvalidPath)
+	_, err = tree.Delete(ctx, "gone", true)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMkdir(t *testing.T) {
+	store := newMemStore()
+	tree := New(store)
+	for range 2 {
+		dir, err := tree.Mkdir(context.Background(), "a/b")
+		require.NoError(t, err)
+		assert.Equal(t, "a/b/", dir.Path)
+	}
+	assert.Equal(t, []string{"a/b/"}, store.keys())
+	_, err := tree.Mkdir(context.Background(), "/")
+	assert.ErrorIs(t, err, ErrInvalidPath)
+}
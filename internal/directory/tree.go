@@ -0,0 +1,257 @@
+package directory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Tree runs folder operations on a store
+type Tree struct {
+	store Store
+}
+
+// New creates a tree over store
+func New(store Store) *Tree {
+	return &Tree{store: store}
+}
+
+// List lists a folder. Folders no key starts with are not found, except
+// the root.
+func (t *Tree) List(ctx context.Context, dir string, recursive bool) (*Listing, error) {
+	dir, err := CleanDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := t.store.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	if dir != "" && len(objects) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, dir)
+	}
+	return Build(dir, objects, recursive), nil
+}
+
+// Mkdir creates a directory object for a folder, so it stays while empty.
+// Creating one that exists succeeds.
+func (t *Tree) Mkdir(ctx context.Context, dir string) (*Directory, error) {
+	dir, err := CleanDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("%w: the root always exists", ErrInvalidPath)
+	}
+	if err := t.store.Mkdir(ctx, dir); err != nil && !errors.Is(err, ErrExists) {
+		return nil, err
+	}
+	return &Directory{Path: dir, Name: Base(dir), Explicit: true}, nil
+}
+
+// Copy copies a file, or a folder and everything below it, to another
+// path. A file copied to a path ending in "/" keeps its name.
+func (t *Tree) Copy(ctx context.Context, from, to string, overwrite bool) (*Report, error) {
+	return t.transfer(ctx, from, to, overwrite, false)
+}
+
+// Move renames a file, or a folder and everything below it. Content stays
+// where it is stored; only the keys change.
+func (t *Tree) Move(ctx context.Context, from, to string, overwrite bool) (*Report, error) {
+	return t.transfer(ctx, from, to, overwrite, true)
+}
+
+func (t *Tree) transfer(ctx context.Context, from, to string, overwrite, move bool) (*Report, error) {
+	pairs, report, err := t.plan(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	op := t.store.Copy
+	if move {
+		op = t.store.Rename
+	}
+
+	// Keys left behind by a move keep the directory objects above them
+	var kept []string
+	for _, p := range pairs {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if move && p.from.IsDir() && below(kept, p.from.Key) {
+			continue
+		}
+		err := op(ctx, p.from.Key, p.to, overwrite)
+		switch {
+		case err == nil:
+			if !p.from.IsDir() {
+				report.Files++
+				report.Bytes += p.from.Size
+			}
+			continue
+		case errors.Is(err, ErrExists):
+			report.Skipped++
+		default:
+			report.Failed++
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", p.from.Key, err))
+		}
+		kept = append(kept, p.from.Key)
+	}
+	return report, nil
+}
+
+// below reports whether any of keys is below the folder dir
+func below(keys []string, dir string) bool {
+	return slices.ContainsFunc(keys, func(k string) bool { return strings.HasPrefix(k, dir) })
+}
+
+type pair struct {
+	from Object
+	to   string
+}
+
+// plan maps the keys below from to their keys below to
+func (t *Tree) plan(ctx context.Context, from, to string) ([]pair, *Report, error) {
+	src, err := CleanKey(from)
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := CleanKey(to)
+	if err != nil {
+		return nil, nil, err
+	}
+	if src == "" {
+		return nil, nil, fmt.Errorf("%w: cannot copy or move the root", ErrInvalidPath)
+	}
+
+	objects, err := t.store.List(ctx, src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A file of that exact key
+	if !strings.HasSuffix(src, Separator) {
+		for _, o := range objects {
+			if o.Key == src {
+				if dst == "" || strings.HasSuffix(dst, Separator) {
+					dst += Base(src)
+				}
+				return []pair{{from: o, to: dst}}, &Report{From: src, To: dst}, nil
+			}
+		}
+		src += Separator
+	}
+
+	// Otherwise a folder
+	if dst != "" && !strings.HasSuffix(dst, Separator) {
+		dst += Separator
+	}
+	if strings.HasPrefix(dst, src) {
+		return nil, nil, fmt.Errorf("%w: cannot copy or move %s into itself", ErrInvalidPath, src)
+	}
+	var pairs []pair
+	for _, o := range objects {
+		if strings.HasPrefix(o.Key, src) {
+			pairs = append(pairs, pair{from: o, to: dst + o.Key[len(src):]})
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, nil, fmt.Errorf("%w: %s", ErrNotFound, strings.TrimSuffix(src, Separator))
+	}
+	if dst == "" {
+		// The folder's own directory object has no key at the root
+		pairs = dropRoot(pairs)
+	}
+	// Files before the directory objects holding them
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].from.IsDir() != pairs[j].from.IsDir() {
+			return !pairs[i].from.IsDir()
+		}
+		return pairs[i].from.Key < pairs[j].from.Key
+	})
+	return pairs, &Report{From: src, To: dst}, nil
+}
+
+func dropRoot(pairs []pair) []pair {
+	kept := pairs[:0]
+	for _, p := range pairs {
+		if p.to != "" {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// Delete deletes a file, or a folder. A folder holding keys other than its
+// directory object is only deleted with recursive.
+func (t *Tree) Delete(ctx context.Context, target string, recursive bool) (*Report, error) {
+	key, err := CleanKey(target)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, fmt.Errorf("%w: cannot delete the root", ErrInvalidPath)
+	}
+	objects, err := t.store.List(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var victims []Object
+	if !strings.HasSuffix(key, Separator) {
+		for _, o := range objects {
+			if o.Key == key {
+				victims = []Object{o}
+				break
+			}
+		}
+		if victims == nil {
+			key += Separator
+		}
+	}
+	if victims == nil {
+		for _, o := range objects {
+			if strings.HasPrefix(o.Key, key) {
+				victims = append(victims, o)
+			}
+		}
+		if len(victims) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, strings.TrimSuffix(key, Separator))
+		}
+		if !recursive && (len(victims) > 1 || victims[0].Key != key) {
+			return nil, fmt.Errorf("%w: %s", ErrNotEmpty, key)
+		}
+	}
+
+	// Directory objects last, and only once nothing is left below them, so
+	// a file that could not be deleted keeps its folder marked
+	sort.Slice(victims, func(i, j int) bool {
+		if victims[i].IsDir() != victims[j].IsDir() {
+			return !victims[i].IsDir()
+		}
+		return victims[i].Key > victims[j].Key
+	})
+	report := &Report{From: key}
+	var kept []string
+	for _, o := range victims {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if o.IsDir() && below(kept, o.Key) {
+			continue
+		}
+		if err := t.store.Delete(ctx, o.Key); err != nil {
+			kept = append(kept, o.Key)
+			report.Failed++
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", o.Key, err))
+			continue
+		}
+		if !o.IsDir() {
+			report.Files++
+			report.Bytes += o.Size
+		}
+	}
+	return report, nil
+}
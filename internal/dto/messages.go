@@ -1,3 +1,6 @@
+// Package dto holds the control messages nodes exchange. The protobuf and
+// CBOR codecs number their fields in declaration order, so fields must only
+// be appended, and proto/p2p.proto updated along with them.
 package dto
 
 // StoreFile announces an incoming file to peers so they can prepare to receive it.
@@ -5,6 +8,12 @@ type StoreFile struct {
 	ID   string
 	Key  string
 	Size int64
+	// Tags and Metadata are the user-defined attributes replicated with the file
+	Tags     []string
+	Metadata map[string]string
+	// UserKey is the key of the file as its user stored it, when the
+	// sender knows it; Key is its hashed key. Peers predating it send none.
+	UserKey string
 }
 
 // GetFile requests a file from peers.
@@ -28,3 +37,202 @@ type StoreFileAck struct {
 	Success   bool
 	Error     string // Empty if success
 }
+
+// LatencyProbe asks a peer to answer with a LatencyReply so the sender can
+// measure the round trip time
+type LatencyProbe struct {
+	ID  string // Node ID of the sender
+	Seq uint64
+}
+
+// LatencyReply answers a LatencyProbe and shares the round trip times the
+// responder measured to its own peers
+type LatencyReply struct {
+	ID    string // Node ID of the responder
+	Addr  string // Listen address of the responder
+	Seq   uint64 // Seq of the probe being answered
+	Links []LatencyLink
+}
+
+// LatencyLink is the measured latency from a node to one of its peers
+type LatencyLink struct {
+	To      string // Node ID of the peer, or its address until it is known
+	Addr    string
+	RTT     int64 // Smoothed round trip time in nanoseconds
+	MinRTT  int64
+	Jitter  int64
+	Loss    float64 // Smoothed share of unanswered probes
+	Samples uint64
+	Updated int64 // Unix nanoseconds of the last sample
+}
+
+// HasFile asks a peer whether it holds a file, without transferring it
+type HasFile struct {
+	RequestID string
+	Key       string
+}
+
+// HasFileAck answers a HasFile request
+type HasFileAck struct {
+	RequestID string // RequestID of the HasFile request
+	ID        string // Node ID of the responder
+	Key       string
+	HasFile   bool
+	Size      int64 // Size of the stored copy
+}
+
+// NodeInfo introduces a node to a newly connected peer so the peer can
+// place it on the hash ring. Nodes being decommissioned send it to every
+// peer with Leaving set, which takes them off the ring. Nodes send it again
+// when their free space changes.
+type NodeInfo struct {
+	ID       string
+	Capacity int64 // Storage capacity in bytes, 0 if not configured
+	Leaving  bool
+	Free     int64 // Free space in bytes, 0 if not measured
+	Full     bool  // Above the high watermark, refusing new replicas
+	// Region, location and public URL route clients to the nearest node
+	Region    string
+	Latitude  float64
+	Longitude float64
+	Located   bool // Latitude and Longitude are set
+	URL       string
+	// Tasks are the handlers the node runs for its peers
+	Tasks []string
+}
+
+// StoreChunk carries part of a file pushed to one of its owners. The
+// chunks of a push share a RequestID, which the receiver answers with a
+// StoreFileAck once the chunk marked Final is stored.
+type StoreChunk struct {
+	RequestID string
+	Key       string
+	Data      []byte
+	Final     bool
+	// Tags, Metadata and Version are sent with the first chunk
+	Tags     []string
+	Metadata map[string]string
+	// Version is the version vector of the file by node ID; peers that
+	// do not track versions send none
+	Version map[string]uint64
+	// UserKey is the key of the file as its user stored it, sent with the
+	// first chunk when the sender knows it; Key is its hashed key
+	UserKey string
+}
+
+// FetchFile asks a peer for the content of a file; the peer answers with
+// FetchChunks carrying the same RequestID
+type FetchFile struct {
+	RequestID string
+	Key       string
+}
+
+// FetchChunk carries part of a fetched file
+type FetchChunk struct {
+	RequestID string
+	Data      []byte
+	Final     bool
+	Missing   bool // Set on the only chunk when the peer does not hold the file
+}
+
+// DocumentDigest lists the shared documents a node holds with a digest of
+// each; the peer answers with the states of the documents that differ, and
+// with its own digest unless Reply is set
+type DocumentDigest struct {
+	Digests map[string]string // by document name
+	Reply   bool
+}
+
+// DocumentState carries the state of a shared document, which the
+// receiver merges into its copy
+type DocumentState struct {
+	Name  string
+	State []byte
+}
+
+// PieceMap asks a peer which pieces of a file it can serve to a swarm
+// download; the peer answers with a PieceMapAck
+type PieceMap struct {
+	RequestID string
+	Key       string
+}
+
+// PieceMapAck answers a PieceMap. Peers holding the file set Complete;
+// peers still downloading it list the pieces they have in Pieces, a bitmap
+// of pieces of PieceSize bytes, lowest bit first.
+type PieceMapAck struct {
+	RequestID string
+	ID        string // Node ID of the responder
+	Key       string
+	Size      int64 // Size of the file, -1 when the peer has none of it
+	Complete  bool
+	PieceSize int64
+	Pieces    []byte
+	// Version is the version vector of the copy, so pieces of different
+	// versions are not mixed
+	Version map[string]uint64
+}
+
+// FetchPiece asks a peer for Length bytes of a file from Offset; the peer
+// answers with a single FetchChunk carrying the same RequestID
+type FetchPiece struct {
+	RequestID string
+	Key       string
+	Offset    int64
+	Length    int64
+}
+
+// AppMessage carries an application message to the node it is addressed
+// to, which answers with an AppReceipt. Nodes send it again until they get
+// one, so receivers ignore the IDs they already queued.
+type AppMessage struct {
+	ID      string
+	From    string // Node IDs of the sender and recipient
+	To      string
+	Channel string
+	Payload []byte
+	// SentAt and ExpiresAt are Unix times in nanoseconds
+	SentAt    int64
+	ExpiresAt int64
+}
+
+// AppReceipt tells the sender of an AppMessage how far it got: "queued",
+// "delivered", "expired" or "rejected"
+type AppReceipt struct {
+	MessageID string
+	Node      string // Node ID of the recipient
+	Channel   string
+	Status    string
+	Time      int64 // Unix time in nanoseconds
+	Error     string
+}
+
+// TaskCall asks a peer to run one of its task handlers. The input and the
+// output of the call follow as TaskData with the same CallID.
+type TaskCall struct {
+	CallID  string
+	Handler string
+	Args    map[string]string
+}
+
+// TaskData carries part of the input or output of a TaskCall, and credit
+// for the stream flowing the other way
+type TaskData struct {
+	CallID string
+	ToHost bool // Sent by the caller: input, and credit for the output
+	Data   []byte
+	Credit int64
+	Final  bool
+	// Code and Error tell why a final TaskData ends the call early
+	Code  string
+	Error string
+}
+
+// FileAttributes carries the tags and metadata of a file after they
+// changed, to the other nodes holding it
+type FileAttributes struct {
+	Key       string // Key of the file as its user stored it
+	HashedKey string
+	Tags      []string
+	Metadata  map[string]string
+}
@@ -0,0 +1,222 @@
+package edge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPullTTL is how long a pulled file is served before the origin is
+// asked whether it changed, when PullOptions.TTL is zero
+const DefaultPullTTL = 5 * time.Minute
+
+var (
+	// ErrNotModified is returned by Origin.Fetch when the file still has
+	// the ETag the edge holds
+	ErrNotModified = errors.New("edge: not modified")
+	// ErrOriginNotFound is returned by Origin.Fetch for files the origin
+	// does not have
+	ErrOriginNotFound = errors.New("edge: not found at the origin")
+)
+
+// Origin is the upstream cluster an edge node pulls files from
+type Origin interface {
+	// Fetch returns the content of key and its ETag. With a non-empty etag
+	// it fails with ErrNotModified while the file still has that ETag.
+	Fetch(ctx context.Context, key, etag string) ([]byte, string, error)
+}
+
+// PullOptions configures a PullThrough
+type PullOptions struct {
+	Origin Origin
+	// TTL is how long a pulled file is served before the origin is asked
+	// whether it changed; zero uses DefaultPullTTL
+	TTL time.Duration
+	// Prefixes limits pulling to keys starting with one of them; empty
+	// pulls every key
+	Prefixes []string
+	// Path persists which files were pulled and when they were last
+	// validated; empty keeps them in memory, so after a restart local
+	// copies are served as if written on the node
+	Path string
+}
+
+// PulledFile is a file an edge node holds on behalf of the origin
+type PulledFile struct {
+	ETag string `json:"etag,omitempty"`
+	Size int64  `json:"size"`
+	// Validated is when the content was last known to match the origin
+	Validated time.Time `json:"validated"`
+}
+
+// PullStats counts what a PullThrough served
+type PullStats struct {
+	// Files and Bytes are the pulled files held by the node
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+	// Pulls are files downloaded from the origin, Revalidations stale
+	// files the origin confirmed unchanged, and StaleServed stale files
+	// served because the origin could not be reached
+	Pulls         int64 `json:"pulls"`
+	Revalidations int64 `json:"revalidations"`
+	StaleServed   int64 `json:"stale_served"`
+	Expired       int64 `json:"expired"`
+}
+
+// PullThrough tracks the files an edge node pulled from its origin, so
+// they are served locally until their TTL runs out and then checked
+// against the origin
+type PullThrough struct {
+	opts  PullOptions
+	mu    sync.Mutex
+	files map[string]PulledFile
+	stats PullStats
+}
+
+// NewPullThrough creates a tracker pulling from opts.Origin, loading the
+// files pulled before a restart from opts.Path
+func NewPullThrough(opts PullOptions) (*PullThrough, error) {
+	if opts.Origin == nil {
+		return nil, errors.New("edge: pull-through needs an origin")
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultPullTTL
+	}
+	p := &PullThrough{opts: opts, files: make(map[string]PulledFile)}
+	if opts.Path == "" {
+		return p, nil
+	}
+	data, err := os.ReadFile(opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &p.files); err != nil {
+		return nil, fmt.Errorf("edge: corrupt pull-through state %s: %w", opts.Path, err)
+	}
+	return p, nil
+}
+
+// TTL returns how long pulled files are served without asking the origin
+func (p *PullThrough) TTL() time.Duration { return p.opts.TTL }
+
+// Pulls reports whether key is pulled from the origin
+func (p *PullThrough) Pulls(key string) bool {
+	return len(p.opts.Prefixes) == 0 || slices.ContainsFunc(p.opts.Prefixes, func(prefix string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// Lookup returns the pulled file held under key and whether it is still
+// within its TTL
+func (p *PullThrough) Lookup(key string) (file PulledFile, fresh, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	file, ok = p.files[key]
+	return file, ok && time.Since(file.Validated) < p.opts.TTL, ok
+}
+
+// Fetch asks the origin for key, sending the ETag of the copy the node
+// holds if any
+func (p *PullThrough) Fetch(ctx context.Context, key string) ([]byte, string, error) {
+	file, _, _ := p.Lookup(key)
+	return p.opts.Origin.Fetch(ctx, key, file.ETag)
+}
+
+// Pulled records that the node stored key as the origin has it
+func (p *PullThrough) Pulled(key, etag string, size int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.files[key] = PulledFile{ETag: etag, Size: size, Validated: time.Now()}
+	p.stats.Pulls++
+	return p.saveLocked()
+}
+
+// Revalidated records that the origin confirmed the copy of key unchanged
+func (p *PullThrough) Revalidated(key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	file, ok := p.files[key]
+	if !ok {
+		return nil
+	}
+	file.Validated = time.Now()
+	p.files[key] = file
+	p.stats.Revalidations++
+	return p.saveLocked()
+}
+
+// ServedStale counts a stale copy served while the origin was unreachable
+func (p *PullThrough) ServedStale() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.StaleServed++
+}
+
+// Forget stops tracking key, which the node no longer holds
+func (p *PullThrough) Forget(key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.files[key]; !ok {
+		return nil
+	}
+	delete(p.files, key)
+	return p.saveLocked()
+}
+
+// Expired returns the pulled files that were not validated for twice
+// their TTL, that is no one asked for them during a whole TTL after they
+// went stale. The node deletes them to make room.
+func (p *PullThrough) Expired() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var keys []string
+	for key, file := range p.files {
+		if time.Since(file.Validated) >= 2*p.opts.TTL {
+			keys = append(keys, key)
+		}
+	}
+	p.stats.Expired += int64(len(keys))
+	slices.Sort(keys)
+	return keys
+}
+
+// Stats returns the counters of the tracker
+func (p *PullThrough) Stats() PullStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	stats.Files = len(p.files)
+	for _, file := range p.files {
+		stats.Bytes += file.Size
+	}
+	return stats
+}
+
+// saveLocked persists the pulled files; callers hold mu
+func (p *PullThrough) saveLocked() error {
+	if p.opts.Path == "" {
+		return nil
+	}
+	data, err := json.Marshal(p.files)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.opts.Path), 0700); err != nil {
+		return err
+	}
+	tmp := p.opts.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.opts.Path)
+}
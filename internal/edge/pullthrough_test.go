@@ -0,0 +1,78 @@
+package edge
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticOrigin map[string]string
+
+func (o staticOrigin) Fetch(ctx context.Context, key, etag string) ([]byte, string, error) {
+	data, ok := o[key]
+	if !ok {
+		return nil, "", ErrOriginNotFound
+	}
+	if etag == `"`+data+`"` {
+		return nil, etag, ErrNotModified
+	}
+	return []byte(data), `"` + data + `"`, nil
+}
+
+func TestPullThroughTracksFreshness(t *testing.T) {
+	p, err := NewPullThrough(PullOptions{Origin: staticOrigin{"a": "v1"}, TTL: 20 * time.Millisecond, Prefixes: []string{"assets/", "a"}})
+	require.NoError(t, err)
+	assert.True(t, p.Pulls("assets/logo.png"))
+	assert.False(t, p.Pulls("private/b"))
+
+	data, etag, err := p.Fetch(context.Background(), "a")
+	require.NoError(t, err)
+	require.NoError(t, p.Pulled("a", etag, int64(len(data))))
+	file, fresh, ok := p.Lookup("a")
+	assert.True(t, ok && fresh)
+	assert.Equal(t, `"v1"`, file.ETag)
+
+	// Once stale, the origin is asked with the held ETag
+	time.Sleep(25 * time.Millisecond)
+	_, fresh, _ = p.Lookup("a")
+	assert.False(t, fresh)
+	_, _, err = p.Fetch(context.Background(), "a")
+	assert.ErrorIs(t, err, ErrNotModified)
+	require.NoError(t, p.Revalidated("a"))
+	_, fresh, _ = p.Lookup("a")
+	assert.True(t, fresh)
+	assert.Empty(t, p.Expired())
+
+	// Not asked for during another TTL, it expires
+	time.Sleep(45 * time.Millisecond)
+	assert.Equal(t, []string{"a"}, p.Expired())
+
+	stats := p.Stats()
+	assert.Equal(t, int64(1), stats.Pulls)
+	assert.Equal(t, int64(1), stats.Revalidations)
+	assert.Equal(t, int64(2), stats.Bytes)
+}
+
+func TestPullThroughPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pulled.json")
+	p, err := NewPullThrough(PullOptions{Origin: staticOrigin{}, Path: path})
+	require.NoError(t, err)
+	require.NoError(t, p.Pulled("a", `"x"`, 1))
+	require.NoError(t, p.Pulled("b", `"y"`, 1))
+	require.NoError(t, p.Forget("b"))
+
+	p, err = NewPullThrough(PullOptions{Origin: staticOrigin{}, Path: path})
+	require.NoError(t, err)
+	file, fresh, ok := p.Lookup("a")
+	assert.True(t, ok && fresh)
+	assert.Equal(t, `"x"`, file.ETag)
+	_, _, ok = p.Lookup("b")
+	assert.False(t, ok)
+
+	_, err = NewPullThrough(PullOptions{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,100 @@
+// Package etag checks the conditional request headers of RFC 9110 against
+// stored objects. The ETag of an object is the hash of its content, a
+// strong validator: it changes with every change of the bytes and never
+// otherwise, so clients revalidate caches with If-None-Match and protect
+// writes against lost updates with If-Match.
+package etag
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// ErrPrecondition is returned when an object does not meet the condition
+// of a request
+var ErrPrecondition = errors.New("etag: precondition failed")
+
+// Any is the tag matching every existing object
+const Any = "*"
+
+// Quote returns the header form of the ETag of content with the given hash
+func Quote(hash string) string {
+	return `"` + hash + `"`
+}
+
+// Parse parses the entity tags of an If-Match or If-None-Match header,
+// without their quotes. Weak tags compare like strong ones, as an object
+// has no other representation than its content.
+func Parse(header string) []string {
+	var tags []string
+	for tag := range strings.SplitSeq(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		tag = strings.Trim(tag, `"`)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Condition is what a request expects of the stored object
+type Condition struct {
+	// IfMatch lists ETags the object must have one of; Any matches any
+	// existing object
+	IfMatch []string
+	// IfNoneMatch lists ETags the object must have none of; Any requires
+	// that no object exists
+	IfNoneMatch []string
+}
+
+// FromRequest reads the condition of a request from its If-Match and
+// If-None-Match headers
+func FromRequest(r *http.Request) Condition {
+	return Condition{
+		IfMatch:     Parse(r.Header.Get("If-Match")),
+		IfNoneMatch: Parse(r.Header.Get("If-None-Match")),
+	}
+}
+
+// IsZero reports whether the condition holds for every object
+func (c Condition) IsZero() bool {
+	return len(c.IfMatch) == 0 && len(c.IfNoneMatch) == 0
+}
+
+// Check checks the condition of a write against the ETag of the stored
+// object, exists telling whether there is one
+func (c Condition) Check(etag string, exists bool) error {
+	if len(c.IfMatch) > 0 {
+		if !exists {
+			return fmt.Errorf("%w: the object does not exist", ErrPrecondition)
+		}
+		if !matches(c.IfMatch, etag) {
+			return fmt.Errorf("%w: the object changed", ErrPrecondition)
+		}
+	}
+	if exists && matches(c.IfNoneMatch, etag) {
+		if slices.Contains(c.IfNoneMatch, Any) {
+			return fmt.Errorf("%w: the object exists", ErrPrecondition)
+		}
+		return fmt.Errorf("%w: the object has not changed", ErrPrecondition)
+	}
+	return nil
+}
+
+// CheckRead checks the condition of a read of an existing object. It fails
+// when If-Match does not hold and reports notModified when the client's
+// copy, named by If-None-Match, is still current.
+func (c Condition) CheckRead(etag string) (notModified bool, err error) {
+	if len(c.IfMatch) > 0 && !matches(c.IfMatch, etag) {
+		return false, fmt.Errorf("%w: the object changed", ErrPrecondition)
+	}
+	return matches(c.IfNoneMatch, etag), nil
+}
+
+// matches reports whether etag is one of tags
+func matches(tags []string, etag string) bool {
+	return slices.Contains(tags, Any) || slices.Contains(tags, etag)
+}
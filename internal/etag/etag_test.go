@@ -0,0 +1,67 @@
+package etag
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	assert.Equal(t, []string{"abc", "def", "*"}, Parse(`"abc", W/"def" ,*`))
+	assert.Empty(t, Parse(""))
+}
+
+func TestFromRequest(t *testing.T) {
+	req := httptest.NewRequest("PUT", "/", nil)
+	req.Header.Set("If-Match", `"abc"`)
+	req.Header.Set("If-None-Match", "*")
+	c := FromRequest(req)
+	assert.Equal(t, []string{"abc"}, c.IfMatch)
+	assert.Equal(t, []string{Any}, c.IfNoneMatch)
+	assert.False(t, c.IsZero())
+	assert.True(t, FromRequest(httptest.NewRequest("GET", "/", nil)).IsZero())
+}
+
+func TestCheckWrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		cond   Condition
+		etag   string
+		exists bool
+		ok     bool
+	}{
+		{"no condition", Condition{}, "abc", true, true},
+		{"if-match holds", Condition{IfMatch: []string{"old", "abc"}}, "abc", true, true},
+		{"if-match changed", Condition{IfMatch: []string{"old"}}, "abc", true, false},
+		{"if-match missing", Condition{IfMatch: []string{Any}}, "", false, false},
+		{"if-match any", Condition{IfMatch: []string{Any}}, "abc", true, true},
+		{"create only", Condition{IfNoneMatch: []string{Any}}, "", false, true},
+		{"create only exists", Condition{IfNoneMatch: []string{Any}}, "abc", true, false},
+		{"if-none-match other", Condition{IfNoneMatch: []string{"old"}}, "abc", true, true},
+		{"if-none-match same", Condition{IfNoneMatch: []string{"abc"}}, "abc", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cond.Check(tt.etag, tt.exists)
+			if tt.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrPrecondition)
+			}
+		})
+	}
+}
+
+func TestCheckRead(t *testing.T) {
+	notModified, err := Condition{IfNoneMatch: []string{"abc"}}.CheckRead("abc")
+	assert.NoError(t, err)
+	assert.True(t, notModified)
+
+	notModified, err = Condition{IfNoneMatch: []string{"old"}}.CheckRead("abc")
+	assert.NoError(t, err)
+	assert.False(t, notModified)
+
+	_, err = Condition{IfMatch: []string{"old"}}.CheckRead("abc")
+	assert.ErrorIs(t, err, ErrPrecondition)
+}
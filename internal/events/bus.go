@@ -0,0 +1,77 @@
+// Package events is an in-process bus for things that happen to files and
+// shared documents, so APIs can tell their clients about them without the
+// file server knowing the APIs.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	// FileConflict is published when a node receives a version of a file
+	// that was written concurrently with its own
+	FileConflict = "file.conflict"
+	// FileConflictResolved is published when the versions of a file are
+	// replaced by one that descends from all of them
+	FileConflictResolved = "file.conflict_resolved"
+	// DocumentUpdated is published when a shared document changes, on
+	// this node or by merging a peer's copy
+	DocumentUpdated = "document.updated"
+)
+
+// Event is something that happened to a file or document
+type Event struct {
+	Type string    `json:"type"`
+	Key  string    `json:"key,omitempty"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+// Bus hands published events to every subscriber. Publishing never
+// blocks: subscribers that fall behind by more than their buffer miss
+// events.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[chan Event]struct{}
+}
+
+// NewBus creates a bus without subscribers
+func NewBus() *Bus {
+	return &Bus{subs: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel receiving events published from now on, and
+// a function that unsubscribes and closes it
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish hands e to the subscribers, stamping its time when unset
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	bus.Publish(Event{Type: "before"})
+
+	a, unsubscribeA := bus.Subscribe(1)
+	b, unsubscribeB := bus.Subscribe(1)
+	defer unsubscribeB()
+
+	bus.Publish(Event{Type: FileConflict, Key: "k"})
+	for _, ch := range []<-chan Event{a, b} {
+		e := <-ch
+		assert.Equal(t, FileConflict, e.Type)
+		assert.Equal(t, "k", e.Key)
+		assert.False(t, e.Time.IsZero())
+	}
+
+	// A full subscriber misses events instead of blocking the publisher
+	bus.Publish(Event{Type: "first"})
+	bus.Publish(Event{Type: "dropped"})
+	for _, ch := range []<-chan Event{a, b} {
+		assert.Equal(t, "first", (<-ch).Type)
+		assert.Empty(t, ch)
+	}
+
+	unsubscribeA()
+	unsubscribeA()
+	_, open := <-a
+	require.False(t, open)
+	bus.Publish(Event{Type: "after"})
+	assert.Equal(t, "after", (<-b).Type)
+}
@@ -0,0 +1,57 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// encodeCSV writes t as CSV with a header row. NULL values are empty and
+// times are RFC 3339 in UTC.
+func encodeCSV(t *table) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	header := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		header[i] = c.name
+	}
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+	record := make([]string, len(t.columns))
+	for _, row := range t.rows {
+		for i, v := range row {
+			s, err := csvCell(v)
+			if err != nil {
+				return nil, fmt.Errorf("export: column %s: %w", t.columns[i].name, err)
+			}
+			record[i] = s
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func csvCell(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case time.Time:
+		if v.IsZero() {
+			return "", nil
+		}
+		return v.UTC().Format(time.RFC3339Nano), nil
+	}
+	data, err := json.Marshal(v)
+	return string(data), err
+}
@@ -0,0 +1,130 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/analytics"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+	"github.com/Skpow1234/Peervault/internal/sqlquery"
+)
+
+// colType is the type of the values of a column
+type colType int
+
+const (
+	typeString colType = iota
+	typeInt
+	typeTime
+	// typeJSON holds lists and maps, written as JSON text
+	typeJSON
+)
+
+// column names a column of a dataset and its type
+type column struct {
+	name string
+	typ  colType
+}
+
+// table is a dataset ready to be encoded; rows hold nil, string, int64,
+// time.Time, []string and map[string]string values
+type table struct {
+	columns []column
+	rows    [][]any
+}
+
+// Source is the data of a node exports read
+type Source struct {
+	// Records returns the file records of the metadata index; nil when the
+	// node has none
+	Records func() []metadata.FileRecord
+	// Analytics holds the access log and rollups; nil when the node counts
+	// no accesses
+	Analytics *analytics.Collector
+}
+
+// metadataTypes are the types of the columns of the metadata tables
+var metadataTypes = map[string]colType{
+	"size":             typeInt,
+	"versions":         typeInt,
+	"version_bytes":    typeInt,
+	"tags":             typeJSON,
+	"metadata":         typeJSON,
+	"created_at":       typeTime,
+	"updated_at":       typeTime,
+	"noncurrent_since": typeTime,
+}
+
+// build reads the dataset of e, with accesses and rollups from its range
+// ending at now
+func build(ctx context.Context, e *Export, src Source, now time.Time) (*table, error) {
+	switch e.Dataset {
+	case Objects, Versions:
+		if src.Records == nil {
+			return nil, fmt.Errorf("%w: %s need the metadata index", ErrUnavailable, e.Dataset)
+		}
+		tables := sqlquery.MetadataTables(src.Records())
+		t := tables[0]
+		if e.Dataset == Versions {
+			t = tables[1]
+		}
+		out := &table{rows: t.Rows}
+		for _, name := range t.Columns {
+			out.columns = append(out.columns, column{name: name, typ: metadataTypes[name]})
+		}
+		return out, nil
+
+	case Accesses:
+		if src.Analytics == nil || !src.Analytics.KeepsAccesses() {
+			return nil, fmt.Errorf("%w: accesses need the access log", ErrUnavailable)
+		}
+		accesses, err := src.Analytics.Accesses(now.Add(-e.Range), now)
+		if err != nil {
+			return nil, err
+		}
+		out := &table{columns: []column{
+			{"time", typeTime}, {"op", typeString}, {"key", typeString},
+			{"tenant", typeString}, {"peer", typeString}, {"bytes", typeInt},
+		}}
+		for _, a := range accesses {
+			out.rows = append(out.rows, []any{a.Time, string(a.Op), a.Key, orNull(a.Tenant), orNull(a.Peer), a.Bytes})
+		}
+		return out, nil
+
+	case Rollups:
+		if src.Analytics == nil {
+			return nil, fmt.Errorf("%w: rollups need access analytics", ErrUnavailable)
+		}
+		dimensions := []analytics.Dimension{analytics.ByKey, analytics.ByTenant, analytics.ByPeer}
+		if e.Dimension != "" {
+			dimensions = []analytics.Dimension{e.Dimension}
+		}
+		out := &table{columns: []column{
+			{"start", typeTime}, {"dimension", typeString}, {"value", typeString},
+			{"reads", typeInt}, {"writes", typeInt}, {"deletes", typeInt},
+			{"bytes_read", typeInt}, {"bytes_written", typeInt},
+		}}
+		for _, d := range dimensions {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			rollups, err := src.Analytics.Query(analytics.Query{Dimension: d, From: now.Add(-e.Range), To: now})
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range rollups {
+				out.rows = append(out.rows, []any{r.Start, string(r.Dimension), r.Value, r.Reads, r.Writes, r.Deletes, r.BytesRead, r.BytesWritten})
+			}
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("export: unknown dataset %q", e.Dataset)
+}
+
+func orNull(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
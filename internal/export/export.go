@@ -0,0 +1,180 @@
+// Package export dumps the object metadata, access log and access rollups
+// of a node as CSV or Parquet files, so they can be loaded into the BI
+// tools of data teams. Exports are defined in a file, run on demand or on
+// a cron schedule, and write to the cluster itself or to a backup target:
+// a directory, an S3 bucket, another PeerVault cluster or a plugin.
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/analytics"
+	"github.com/Skpow1234/Peervault/internal/backup"
+	"github.com/Skpow1234/Peervault/internal/scheduler"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultPrefix is the key prefix exports are written under when they
+	// set none
+	DefaultPrefix = "exports/"
+	// DefaultRange is how far back exports of accesses and rollups look
+	// when they set no range
+	DefaultRange = 24 * time.Hour
+)
+
+var (
+	// ErrNotFound is returned for an unknown export name
+	ErrNotFound = errors.New("export: export not found")
+	// ErrUnavailable is returned for exports of data the node does not
+	// keep, such as accesses without an access log
+	ErrUnavailable = errors.New("export: dataset not kept by this node")
+)
+
+// Dataset is the data an export dumps
+type Dataset string
+
+const (
+	// Objects has a row per file with its metadata
+	Objects Dataset = "objects"
+	// Versions has a row per noncurrent version of a file
+	Versions Dataset = "versions"
+	// Accesses has a row per read, write or delete in the access log
+	Accesses Dataset = "accesses"
+	// Rollups has a row per access rollup of a key, tenant or peer
+	Rollups Dataset = "rollups"
+)
+
+// Datasets are every dataset exports can dump
+var Datasets = []Dataset{Objects, Versions, Accesses, Rollups}
+
+// Format is the file format of an export
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatParquet Format = "parquet"
+)
+
+// ContentType returns the media type of the format
+func (f Format) ContentType() string {
+	if f == FormatParquet {
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv"
+}
+
+// exportName matches the names of exports, which name their scheduler jobs
+var exportName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Export defines what an export dumps, how, when and where
+type Export struct {
+	Name    string  `json:"name" yaml:"name"`
+	Dataset Dataset `json:"dataset" yaml:"dataset"`
+	// Format defaults to Parquet
+	Format Format `json:"format,omitempty" yaml:"format,omitempty"`
+	// Schedule is the cron expression the export runs on; empty runs it on
+	// demand only
+	Schedule string `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	// Range is how far back from the run exports of accesses and rollups
+	// look; defaults to DefaultRange
+	Range time.Duration `json:"range,omitempty" yaml:"range,omitempty"`
+	// Dimension restricts an export of rollups to those by key, tenant or
+	// peer; empty exports all of them
+	Dimension analytics.Dimension `json:"dimension,omitempty" yaml:"dimension,omitempty"`
+	// Prefix is the key prefix files are written under, followed by the
+	// export name and the time of the run; defaults to DefaultPrefix
+	Prefix string `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+	// Target is where files are written; nil stores them in the cluster
+	// like any other file
+	Target *backup.TargetConfig `json:"target,omitempty" yaml:"target,omitempty"`
+}
+
+// ext returns the file extension of the export's files
+func (e *Export) ext() string { return string(e.Format) }
+
+// Config is a set of exports
+type Config struct {
+	Exports []Export `json:"exports" yaml:"exports"`
+}
+
+// Validate checks the exports and fills in their defaults
+func (c *Config) Validate() error {
+	names := make(map[string]bool)
+	for i := range c.Exports {
+		e := &c.Exports[i]
+		if !exportName.MatchString(e.Name) {
+			return fmt.Errorf("export: invalid export name %q: use letters, digits, - and _", e.Name)
+		}
+		if names[e.Name] {
+			return fmt.Errorf("export: duplicate export %q", e.Name)
+		}
+		names[e.Name] = true
+		if !validDataset(e.Dataset) {
+			return fmt.Errorf("export: export %s: dataset must be objects, versions, accesses or rollups", e.Name)
+		}
+		switch e.Format {
+		case "":
+			e.Format = FormatParquet
+		case FormatCSV, FormatParquet:
+		default:
+			return fmt.Errorf("export: export %s: format must be csv or parquet", e.Name)
+		}
+		if e.Schedule != "" {
+			if _, err := scheduler.ParseCron(e.Schedule); err != nil {
+				return fmt.Errorf("export: export %s: %w", e.Name, err)
+			}
+		}
+		if e.Range < 0 {
+			return fmt.Errorf("export: export %s: range must not be negative", e.Name)
+		}
+		if e.Range == 0 {
+			e.Range = DefaultRange
+		}
+		if e.Dimension != "" {
+			if _, err := analytics.ParseDimension(string(e.Dimension)); err != nil {
+				return fmt.Errorf("export: export %s: %w", e.Name, err)
+			}
+		}
+		if e.Prefix == "" {
+			e.Prefix = DefaultPrefix
+		}
+	}
+	return nil
+}
+
+func validDataset(d Dataset) bool {
+	for _, dataset := range Datasets {
+		if d == dataset {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadConfig reads exports from a YAML or JSON file, which holds either
+// {"exports": [...]} or a bare list of exports
+func LoadConfig(path string) (Config, error) {
+	var c Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	unmarshal := yaml.Unmarshal
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		unmarshal = json.Unmarshal
+	}
+	if err := unmarshal(data, &c); err != nil {
+		if err := unmarshal(data, &c.Exports); err != nil {
+			return c, fmt.Errorf("export: invalid exports %s: %w", path, err)
+		}
+	}
+	return c, c.Validate()
+}
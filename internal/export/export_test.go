@@ -0,0 +1,162 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/analytics"
+	"github.com/Skpow1234/Peervault/internal/backup"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var day = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func testSource(t *testing.T) Source {
+	collector := analytics.NewCollector(analytics.Options{AccessLogDir: t.TempDir()})
+	collector.Record(analytics.Access{Time: time.Now().Add(-time.Hour), Op: analytics.OpWrite, Key: "acme/report.pdf", Tenant: "acme", Bytes: 300})
+	collector.Record(analytics.Access{Time: time.Now().Add(-48 * time.Hour), Op: analytics.OpRead, Key: "acme/report.pdf", Tenant: "acme", Bytes: 300})
+	return Source{
+		Records: func() []metadata.FileRecord {
+			return []metadata.FileRecord{
+				{Key: "acme/report.pdf", Size: 300, ContentType: "application/pdf", Owner: "acme", Tags: []string{"finance", "q1"}, CreatedAt: day, UpdatedAt: day},
+				{Key: "tmp/a,b.txt", Size: 5, CreatedAt: day, UpdatedAt: day, Versions: []metadata.VersionRecord{{ID: "v1", Size: 4, CreatedAt: day}}},
+			}
+		},
+		Analytics: collector,
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := Config{Exports: []Export{{Name: "objects", Dataset: Objects}}}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, Export{Name: "objects", Dataset: Objects, Format: FormatParquet, Range: DefaultRange, Prefix: DefaultPrefix}, cfg.Exports[0])
+
+	for _, e := range []Export{
+		{Name: "bad name", Dataset: Objects},
+		{Name: "x", Dataset: "files"},
+		{Name: "x", Dataset: Objects, Format: "xlsx"},
+		{Name: "x", Dataset: Objects, Schedule: "every day"},
+		{Name: "x", Dataset: Rollups, Dimension: "bucket"},
+		{Name: "x", Dataset: Accesses, Range: -time.Hour},
+	} {
+		cfg := Config{Exports: []Export{e}}
+		assert.Error(t, cfg.Validate(), "%+v", e)
+	}
+	cfg = Config{Exports: []Export{{Name: "x", Dataset: Objects}, {Name: "x", Dataset: Versions}}}
+	assert.ErrorContains(t, cfg.Validate(), "duplicate")
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "exports.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+exports:
+  - name: nightly-objects
+    dataset: objects
+    format: csv
+    schedule: "0 2 * * *"
+  - name: accesses
+    dataset: accesses
+    range: 168h
+    target:
+      type: dir
+      path: /var/exports
+`), 0600))
+	cfg, err := LoadConfig(path)
+	require.NoError(t, err)
+	require.Len(t, cfg.Exports, 2)
+	assert.Equal(t, FormatCSV, cfg.Exports[0].Format)
+	assert.Equal(t, 168*time.Hour, cfg.Exports[1].Range)
+	assert.Equal(t, "/var/exports", cfg.Exports[1].Target.Path)
+
+	require.NoError(t, os.WriteFile(path, []byte("- name: v\n  dataset: versions\n"), 0600))
+	cfg, err = LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, Versions, cfg.Exports[0].Dataset)
+}
+
+func TestRunStoresInCluster(t *testing.T) {
+	x, err := New(Config{Exports: []Export{{Name: "objects", Dataset: Objects, Format: FormatCSV}}})
+	require.NoError(t, err)
+	x.now = func() time.Time { return day }
+	stored := make(map[string][]byte)
+	store := func(ctx context.Context, key string, data []byte) error {
+		stored[key] = data
+		return nil
+	}
+
+	run, err := x.Run(context.Background(), "objects", testSource(t), store)
+	require.NoError(t, err)
+	assert.Equal(t, "exports/objects/20240301T120000Z.csv", run.Key)
+	assert.Equal(t, "cluster", run.Destination)
+	assert.Equal(t, 2, run.Rows)
+	lines := strings.Split(strings.TrimSpace(string(stored[run.Key])), "\n")
+	require.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "key,name,size,content_type,hash,owner,tenant,tags,metadata,"))
+	assert.Equal(t, `acme/report.pdf,,300,application/pdf,,acme,acme,"[""finance"",""q1""]",{},hot,0,0,2024-03-01T12:00:00Z,2024-03-01T12:00:00Z`, lines[1])
+	assert.True(t, strings.HasPrefix(lines[2], `"tmp/a,b.txt",,5,`))
+
+	infos := x.List()
+	require.Len(t, infos, 1)
+	assert.Equal(t, run.Key, infos[0].LastKey)
+	assert.Equal(t, 2, infos[0].LastRows)
+
+	_, err = x.Run(context.Background(), "missing", testSource(t), store)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRunWritesToTarget(t *testing.T) {
+	dir := t.TempDir()
+	x, err := New(Config{Exports: []Export{
+		{Name: "accesses", Dataset: Accesses, Prefix: "bi/", Target: &backup.TargetConfig{Type: "dir", Path: dir}},
+		{Name: "tenants", Dataset: Rollups, Dimension: analytics.ByTenant, Format: FormatCSV, Range: 7 * 24 * time.Hour, Target: &backup.TargetConfig{Type: "dir", Path: dir}},
+	}})
+	require.NoError(t, err)
+	src := testSource(t)
+	noStore := func(ctx context.Context, key string, data []byte) error {
+		t.Fatalf("stored %s in the cluster", key)
+		return nil
+	}
+
+	run, err := x.Run(context.Background(), "accesses", src, noStore)
+	require.NoError(t, err)
+	assert.Equal(t, 1, run.Rows, "only accesses of the last day")
+	assert.Equal(t, "dir:"+dir, run.Destination)
+	assert.True(t, strings.HasPrefix(run.Key, "bi/accesses/") && strings.HasSuffix(run.Key, ".parquet"))
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(run.Key)))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("PAR1"), data[:4])
+	assert.Equal(t, []byte("PAR1"), data[len(data)-4:])
+	footer := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	assert.Less(t, footer, len(data)-12)
+	assert.True(t, bytes.Contains(data[len(data)-8-footer:], []byte("tenant")), "the schema names the columns")
+
+	run, err = x.Run(context.Background(), "tenants", src, noStore)
+	require.NoError(t, err)
+	data, err = os.ReadFile(filepath.Join(dir, filepath.FromSlash(run.Key)))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "start,dimension,value,reads,writes,deletes,bytes_read,bytes_written", lines[0])
+	assert.Contains(t, lines[1], ",tenant,acme,")
+}
+
+func TestRunUnavailable(t *testing.T) {
+	x, err := New(Config{Exports: []Export{{Name: "accesses", Dataset: Accesses}, {Name: "objects", Dataset: Objects}}})
+	require.NoError(t, err)
+	store := func(ctx context.Context, key string, data []byte) error { return nil }
+
+	_, err = x.Run(context.Background(), "accesses", Source{Analytics: analytics.NewCollector(analytics.Options{})}, store)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	_, err = x.Run(context.Background(), "objects", Source{}, store)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.Contains(t, x.List()[1].LastError, "metadata index")
+}
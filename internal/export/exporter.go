@@ -0,0 +1,164 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/backup"
+)
+
+// StoreFunc stores the file of an export run in the cluster under key
+type StoreFunc func(ctx context.Context, key string, data []byte) error
+
+// Status is the outcome of an export's last run
+type Status struct {
+	LastRun   time.Time `json:"last_run,omitempty"`
+	LastKey   string    `json:"last_key,omitempty"`
+	LastRows  int       `json:"last_rows,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Info is an export with where it writes and its status
+type Info struct {
+	Export
+	// Destination describes where files are written
+	Destination string `json:"destination"`
+	Status
+}
+
+// Run is the outcome of running an export
+type Run struct {
+	Export      string    `json:"export"`
+	Dataset     Dataset   `json:"dataset"`
+	Format      Format    `json:"format"`
+	Destination string    `json:"destination"`
+	Key         string    `json:"key"`
+	Rows        int       `json:"rows"`
+	Size        int       `json:"size"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// Exporter runs the exports of a node
+type Exporter struct {
+	exports []Export
+	targets map[string]backup.Target
+	now     func() time.Time
+
+	mu     sync.Mutex
+	status map[string]Status
+}
+
+// New creates the exporter of cfg, connecting to the targets of its
+// exports
+func New(cfg Config) (*Exporter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	x := &Exporter{
+		exports: cfg.Exports,
+		targets: make(map[string]backup.Target),
+		now:     time.Now,
+		status:  make(map[string]Status),
+	}
+	for _, e := range cfg.Exports {
+		if e.Target == nil {
+			continue
+		}
+		target, err := backup.NewTarget(*e.Target)
+		if err != nil {
+			return nil, fmt.Errorf("export: export %s: %w", e.Name, err)
+		}
+		x.targets[e.Name] = target
+	}
+	return x, nil
+}
+
+// Exports returns the exports in the order they are defined
+func (x *Exporter) Exports() []Export {
+	return x.exports
+}
+
+// List returns the exports with their status, in the order they are
+// defined
+func (x *Exporter) List() []Info {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	infos := make([]Info, len(x.exports))
+	for i, e := range x.exports {
+		infos[i] = Info{Export: e, Destination: x.destination(e.Name), Status: x.status[e.Name]}
+	}
+	return infos
+}
+
+func (x *Exporter) destination(name string) string {
+	if target, ok := x.targets[name]; ok {
+		return target.String()
+	}
+	return "cluster"
+}
+
+func (x *Exporter) find(name string) (*Export, error) {
+	for i := range x.exports {
+		if x.exports[i].Name == name {
+			return &x.exports[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+}
+
+// Run dumps the dataset of an export from src and writes it to the
+// export's target, or stores it in the cluster with store when it has
+// none. Accesses and rollups cover the export's range ending now.
+func (x *Exporter) Run(ctx context.Context, name string, src Source, store StoreFunc) (*Run, error) {
+	e, err := x.find(name)
+	if err != nil {
+		return nil, err
+	}
+	now := x.now()
+	run := &Run{
+		Export:      name,
+		Dataset:     e.Dataset,
+		Format:      e.Format,
+		Destination: x.destination(name),
+		Key:         fmt.Sprintf("%s%s/%s.%s", e.Prefix, name, now.UTC().Format("20060102T150405Z"), e.ext()),
+		GeneratedAt: now,
+	}
+	err = x.write(ctx, e, src, store, now, run)
+	x.mu.Lock()
+	status := Status{LastRun: now, LastKey: x.status[name].LastKey, LastRows: x.status[name].LastRows}
+	if err != nil {
+		status.LastError = err.Error()
+	} else {
+		status.LastKey, status.LastRows = run.Key, run.Rows
+	}
+	x.status[name] = status
+	x.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func (x *Exporter) write(ctx context.Context, e *Export, src Source, store StoreFunc, now time.Time, run *Run) error {
+	t, err := build(ctx, e, src, now)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if e.Format == FormatCSV {
+		data, err = encodeCSV(t)
+	} else {
+		data, err = encodeParquet(t)
+	}
+	if err != nil {
+		return err
+	}
+	run.Rows, run.Size = len(t.rows), len(data)
+	if target, ok := x.targets[e.Name]; ok {
+		return target.Put(ctx, run.Key, bytes.NewReader(data), int64(len(data)))
+	}
+	return store(ctx, run.Key, data)
+}
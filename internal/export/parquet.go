@@ -0,0 +1,359 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// A Parquet file is written with the rows split in row groups of at most
+// parquetRowGroup rows, each column of a group in one data page (version
+// 1) of PLAIN values, compressed with gzip. Every column is optional, its
+// NULLs marked by definition levels. The metadata is Thrift, in the
+// compact protocol, as the format requires:
+// https://github.com/apache/parquet-format
+const parquetRowGroup = 64 << 10
+
+var parquetMagic = []byte("PAR1")
+
+// Physical types, converted types and other enums of the format
+const (
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	convertedUTF8            = 0
+	convertedTimestampMicros = 10
+	convertedJSON            = 19
+
+	repetitionOptional = 1
+	encodingPlain      = 0
+	encodingRLE        = 3
+	codecGzip          = 2
+	pageData           = 0
+)
+
+// encodeParquet writes t as a Parquet file
+func encodeParquet(t *table) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(parquetMagic)
+
+	var groups [][]columnChunk
+	for start := 0; start < len(t.rows); start += parquetRowGroup {
+		rows := t.rows[start:min(start+parquetRowGroup, len(t.rows))]
+		chunks := make([]columnChunk, len(t.columns))
+		for i, c := range t.columns {
+			chunk, err := writeColumnChunk(&buf, c, rows, i)
+			if err != nil {
+				return nil, err
+			}
+			chunks[i] = chunk
+		}
+		groups = append(groups, chunks)
+	}
+
+	var meta thriftWriter
+	meta.i32(1, 1) // version
+	meta.listBegin(2, thriftStruct, len(t.columns)+1)
+	meta.structBegin()
+	meta.binary(4, []byte("schema"))
+	meta.i32(5, int32(len(t.columns)))
+	meta.structEnd()
+	for _, c := range t.columns {
+		meta.structBegin()
+		writeSchemaElement(&meta, c)
+		meta.structEnd()
+	}
+	meta.i64(3, int64(len(t.rows)))
+	meta.listBegin(4, thriftStruct, len(groups))
+	for g, chunks := range groups {
+		rows := min(parquetRowGroup, len(t.rows)-g*parquetRowGroup)
+		var size int64
+		for _, chunk := range chunks {
+			size += chunk.uncompressed
+		}
+		meta.structBegin()
+		meta.listBegin(1, thriftStruct, len(chunks))
+		for _, chunk := range chunks {
+			meta.structBegin()
+			meta.i64(2, chunk.offset)
+			meta.fieldStructBegin(3)
+			meta.i32(1, chunk.physical)
+			meta.listBegin(2, thriftI32, 2)
+			meta.varint(zigzag(encodingPlain))
+			meta.varint(zigzag(encodingRLE))
+			meta.listBegin(3, thriftBinary, 1)
+			meta.bytes([]byte(chunk.name))
+			meta.i32(4, codecGzip)
+			meta.i64(5, int64(rows))
+			meta.i64(6, chunk.uncompressed)
+			meta.i64(7, chunk.compressed)
+			meta.i64(9, chunk.offset)
+			meta.structEnd()
+			meta.structEnd()
+		}
+		meta.i64(2, size)
+		meta.i64(3, int64(rows))
+		meta.structEnd()
+	}
+	meta.binary(6, []byte("PeerVault"))
+	meta.stop()
+
+	buf.Write(meta.buf)
+	buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(meta.buf))))
+	buf.Write(parquetMagic)
+	return buf.Bytes(), nil
+}
+
+// columnChunk is a column of a row group written to the file
+type columnChunk struct {
+	name         string
+	physical     int32
+	offset       int64
+	compressed   int64
+	uncompressed int64
+}
+
+func physicalType(c column) int32 {
+	switch c.typ {
+	case typeInt, typeTime:
+		return parquetInt64
+	}
+	return parquetByteArray
+}
+
+func writeSchemaElement(w *thriftWriter, c column) {
+	w.i32(1, physicalType(c))
+	w.i32(3, repetitionOptional)
+	w.binary(4, []byte(c.name))
+	switch c.typ {
+	case typeString:
+		w.i32(6, convertedUTF8)
+		w.fieldStructBegin(10)
+		w.fieldStructBegin(1) // STRING
+		w.structEnd()
+		w.structEnd()
+	case typeJSON:
+		w.i32(6, convertedJSON)
+		w.fieldStructBegin(10)
+		w.fieldStructBegin(12) // JSON
+		w.structEnd()
+		w.structEnd()
+	case typeTime:
+		w.i32(6, convertedTimestampMicros)
+		w.fieldStructBegin(10)
+		w.fieldStructBegin(8) // TIMESTAMP
+		w.bool(1, true)       // adjusted to UTC
+		w.fieldStructBegin(2)
+		w.fieldStructBegin(2) // MICROS
+		w.structEnd()
+		w.structEnd()
+		w.structEnd()
+		w.structEnd()
+	}
+}
+
+// writeColumnChunk writes column i of rows as one data page
+func writeColumnChunk(buf *bytes.Buffer, c column, rows [][]any, i int) (columnChunk, error) {
+	levels := make([]byte, len(rows))
+	var values []byte
+	for r, row := range rows {
+		v, err := parquetValue(c, row[i])
+		if err != nil {
+			return columnChunk{}, fmt.Errorf("export: column %s: %w", c.name, err)
+		}
+		if v == nil {
+			continue
+		}
+		levels[r] = 1
+		values = append(values, v...)
+	}
+	rle := encodeLevels(levels)
+	page := binary.LittleEndian.AppendUint32(nil, uint32(len(rle)))
+	page = append(page, rle...)
+	page = append(page, values...)
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(page); err != nil {
+		return columnChunk{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return columnChunk{}, err
+	}
+
+	var header thriftWriter
+	header.i32(1, pageData)
+	header.i32(2, int32(len(page)))
+	header.i32(3, int32(compressed.Len()))
+	header.fieldStructBegin(5)
+	header.i32(1, int32(len(rows)))
+	header.i32(2, encodingPlain)
+	header.i32(3, encodingRLE)
+	header.i32(4, encodingRLE)
+	header.structEnd()
+	header.stop()
+
+	chunk := columnChunk{
+		name:         c.name,
+		physical:     physicalType(c),
+		offset:       int64(buf.Len()),
+		compressed:   int64(len(header.buf) + compressed.Len()),
+		uncompressed: int64(len(header.buf) + len(page)),
+	}
+	buf.Write(header.buf)
+	buf.Write(compressed.Bytes())
+	return chunk, nil
+}
+
+// parquetValue returns the PLAIN encoding of v, nil for NULL. Zero times
+// are NULL.
+func parquetValue(c column, v any) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch c.typ {
+	case typeInt:
+		n, ok := v.(int64)
+		if !ok {
+			return nil, fmt.Errorf("%T is not an integer", v)
+		}
+		return binary.LittleEndian.AppendUint64(nil, uint64(n)), nil
+	case typeTime:
+		t, ok := v.(time.Time)
+		if !ok {
+			return nil, fmt.Errorf("%T is not a time", v)
+		}
+		if t.IsZero() {
+			return nil, nil
+		}
+		return binary.LittleEndian.AppendUint64(nil, uint64(t.UnixMicro())), nil
+	case typeJSON:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return byteArray(data), nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a string", v)
+	}
+	return byteArray([]byte(s)), nil
+}
+
+func byteArray(data []byte) []byte {
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(data))), data...)
+}
+
+// encodeLevels encodes definition levels of bit width 1 in the RLE
+// hybrid encoding, as runs of repeated values
+func encodeLevels(levels []byte) []byte {
+	var out []byte
+	for i := 0; i < len(levels); {
+		n := 1
+		for i+n < len(levels) && levels[i+n] == levels[i] {
+			n++
+		}
+		out = binary.AppendUvarint(out, uint64(n)<<1)
+		out = append(out, levels[i])
+		i += n
+	}
+	return out
+}
+
+// Thrift compact protocol types
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter writes structs in the Thrift compact protocol. Field ids
+// are written as deltas from the previous field of the same struct.
+type thriftWriter struct {
+	buf   []byte
+	last  int16
+	stack []int16
+}
+
+func (w *thriftWriter) varint(v uint64) { w.buf = binary.AppendUvarint(w.buf, v) }
+
+func (w *thriftWriter) bytes(data []byte) {
+	w.varint(uint64(len(data)))
+	w.buf = append(w.buf, data...)
+}
+
+func zigzag(v int64) uint64 { return uint64((v << 1) ^ (v >> 63)) }
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	if delta := id - w.last; delta > 0 && delta <= 15 {
+		w.buf = append(w.buf, byte(delta)<<4|typ)
+	} else {
+		w.buf = append(w.buf, typ)
+		w.varint(zigzag(int64(id)))
+	}
+	w.last = id
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.varint(zigzag(int64(v)))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.varint(zigzag(v))
+}
+
+func (w *thriftWriter) bool(id int16, v bool) {
+	if v {
+		w.field(id, thriftTrue)
+	} else {
+		w.field(id, thriftFalse)
+	}
+}
+
+func (w *thriftWriter) binary(id int16, data []byte) {
+	w.field(id, thriftBinary)
+	w.bytes(data)
+}
+
+// listBegin starts a list field of n elements, which follow it
+func (w *thriftWriter) listBegin(id int16, elem byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.buf = append(w.buf, byte(n)<<4|elem)
+	} else {
+		w.buf = append(w.buf, 0xf0|elem)
+		w.varint(uint64(n))
+	}
+}
+
+// structBegin starts a struct element of a list
+func (w *thriftWriter) structBegin() {
+	w.stack = append(w.stack, w.last)
+	w.last = 0
+}
+
+// fieldStructBegin starts a struct field
+func (w *thriftWriter) fieldStructBegin(id int16) {
+	w.field(id, thriftStruct)
+	w.structBegin()
+}
+
+// structEnd ends the struct started last
+func (w *thriftWriter) structEnd() {
+	w.stop()
+	w.last = w.stack[len(w.stack)-1]
+	w.stack = w.stack[:len(w.stack)-1]
+}
+
+// stop ends the top-level struct
+func (w *thriftWriter) stop() { w.buf = append(w.buf, 0) }
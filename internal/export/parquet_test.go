@@ -0,0 +1,128 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeLevels(t *testing.T) {
+	assert.Equal(t, []byte{3 << 1, 1, 2 << 1, 0, 1 << 1, 1}, encodeLevels([]byte{1, 1, 1, 0, 0, 1}))
+	assert.Empty(t, encodeLevels(nil))
+	// Runs of 64 and more take a longer header
+	levels := make([]byte, 200)
+	assert.Equal(t, []byte{0x90, 0x03, 0}, encodeLevels(levels))
+}
+
+func TestThriftWriter(t *testing.T) {
+	var w thriftWriter
+	w.i32(1, 1)
+	w.listBegin(2, thriftStruct, 1)
+	w.structBegin()
+	w.binary(4, []byte("a"))
+	w.structEnd()
+	w.i64(20, -2)
+	w.bool(21, true)
+	w.stop()
+	assert.Equal(t, []byte{
+		0x15, 0x02, // field 1 i32 1
+		0x19, 0x1c, // field 2 list of 1 struct
+		0x48, 0x01, 'a', 0x00, // field 4 binary "a", end of struct
+		0x06, 0x28, 0x03, // field 20 i64 -2, in long form
+		0x11, // field 21 true
+		0x00,
+	}, w.buf)
+}
+
+// readParquet opens data with an independent Parquet reader and returns its
+// schema and rows, so files are checked the way BI tools read them
+func readParquet(t *testing.T, data []byte) (*parquet.File, []parquet.Row) {
+	t.Helper()
+	f, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	var rows []parquet.Row
+	for _, group := range f.RowGroups() {
+		r := group.Rows()
+		buf := make([]parquet.Row, 1024)
+		for {
+			n, err := r.ReadRows(buf)
+			for _, row := range buf[:n] {
+				rows = append(rows, row.Clone())
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+		}
+		require.NoError(t, r.Close())
+	}
+	return f, rows
+}
+
+func TestParquetReadBack(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 0, 123456000, time.UTC)
+	data, err := encodeParquet(&table{
+		columns: []column{{"key", typeString}, {"size", typeInt}, {"created_at", typeTime}, {"tags", typeJSON}},
+		rows: [][]any{
+			{"reports/q1.csv", int64(2048), created, []string{"finance", "q1"}},
+			{"empty.txt", nil, time.Time{}, nil},
+			{nil, int64(-1), created.Add(time.Hour), map[string]string{"owner": "ana"}},
+		},
+	})
+	require.NoError(t, err)
+	f, rows := readParquet(t, data)
+
+	fields := f.Schema().Fields()
+	require.Len(t, fields, 4)
+	for i, name := range []string{"key", "size", "created_at", "tags"} {
+		assert.Equal(t, name, fields[i].Name())
+		assert.True(t, fields[i].Optional(), "column %s is optional", name)
+	}
+	assert.Equal(t, parquet.ByteArray, fields[0].Type().Kind())
+	assert.NotNil(t, fields[0].Type().LogicalType().UTF8)
+	assert.Equal(t, parquet.Int64, fields[1].Type().Kind())
+	assert.Equal(t, parquet.Int64, fields[2].Type().Kind())
+	timestamp := fields[2].Type().LogicalType().Timestamp
+	require.NotNil(t, timestamp)
+	assert.True(t, timestamp.IsAdjustedToUTC)
+	assert.NotNil(t, timestamp.Unit.Micros)
+	assert.NotNil(t, fields[3].Type().LogicalType().Json)
+
+	assert.EqualValues(t, 3, f.NumRows())
+	require.Len(t, rows, 3)
+	assert.Equal(t, "reports/q1.csv", string(rows[0][0].ByteArray()))
+	assert.Equal(t, int64(2048), rows[0][1].Int64())
+	assert.Equal(t, created, time.UnixMicro(rows[0][2].Int64()).UTC())
+	assert.JSONEq(t, `["finance","q1"]`, string(rows[0][3].ByteArray()))
+
+	// NULLs, a zero time among them
+	assert.Equal(t, "empty.txt", string(rows[1][0].ByteArray()))
+	for _, i := range []int{1, 2, 3} {
+		assert.True(t, rows[1][i].IsNull(), "column %d of the second row is NULL", i)
+	}
+	assert.True(t, rows[2][0].IsNull())
+	assert.Equal(t, int64(-1), rows[2][1].Int64())
+	assert.Equal(t, created.Add(time.Hour), time.UnixMicro(rows[2][2].Int64()).UTC())
+	assert.JSONEq(t, `{"owner":"ana"}`, string(rows[2][3].ByteArray()))
+}
+
+func TestParquetRowGroups(t *testing.T) {
+	tbl := &table{columns: []column{{"size", typeInt}}}
+	for i := range parquetRowGroup + 10 {
+		tbl.rows = append(tbl.rows, []any{int64(i)})
+	}
+	data, err := encodeParquet(tbl)
+	require.NoError(t, err)
+	f, rows := readParquet(t, data)
+
+	assert.Len(t, f.RowGroups(), 2)
+	assert.EqualValues(t, parquetRowGroup+10, f.NumRows())
+	require.Len(t, rows, parquetRowGroup+10)
+	assert.Equal(t, int64(parquetRowGroup+9), rows[parquetRowGroup+9][0].Int64())
+}
@@ -0,0 +1,482 @@
+// Package firmware distributes firmware images to IoT devices in staged
+// rollouts. Images are stored as objects of the node, so they replicate
+// like files, and are signed with the node's firmware signing key. A
+// rollout releases an image ring by ring: each ring adds a share of all
+// devices, device groups (tenants) or named devices. Devices check for
+// updates over HTTPS, CoAP or MQTT, download the image over any of them,
+// and report how the installation went, which can pause the rollout.
+package firmware
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/audit"
+	"github.com/Skpow1234/Peervault/internal/devices"
+)
+
+// Prefix is the key prefix images are stored under
+const Prefix = ".firmware/"
+
+// Where devices reach the distribution: the REST API path images and
+// updates are under, the CoAP path and the MQTT topic prefix
+const (
+	HTTPPath    = "/api/v1/firmware"
+	CoAPPath    = "/fw"
+	TopicPrefix = "firmware/"
+)
+
+// MaxImageSize is the largest image in bytes
+const MaxImageSize = 64 << 20
+
+var (
+	// ErrNotFound is returned for images and rollouts that do not exist
+	ErrNotFound = errors.New("firmware: not found")
+	// ErrInvalid is returned for malformed images, rollouts and reports
+	ErrInvalid = errors.New("firmware: invalid request")
+	// ErrExists is returned for images whose name and version are taken
+	ErrExists = errors.New("firmware: image already exists")
+	// ErrInUse is returned when deleting images of rollouts that are not
+	// cancelled
+	ErrInUse = errors.New("firmware: image in use")
+	// ErrDenied is returned for devices that are unknown or revoked
+	ErrDenied = errors.New("firmware: denied")
+	// ErrNoUpdate is returned to devices no rollout offers an update
+	ErrNoUpdate = errors.New("firmware: no update")
+)
+
+// validName matches image names and versions, and device IDs
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]{0,63}$`)
+
+// Image is a firmware image
+type Image struct {
+	ID string `json:"id"`
+	// Name is the product the image is for
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Size    int64  `json:"size"`
+	// SHA256 is the hex digest of the image
+	SHA256 string `json:"sha256"`
+	// Signature is the base64 Ed25519 signature of SignedMessage by the
+	// signing key KeyID names
+	Signature string    `json:"signature"`
+	KeyID     string    `json:"key_id"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SignedMessage is what the signature of an image signs: its name,
+// version, size and digest, so devices can check them all
+func SignedMessage(img Image) []byte {
+	return fmt.Appendf(nil, "peervault-firmware\n%s\n%s\n%d\n%s", img.Name, img.Version, img.Size, img.SHA256)
+}
+
+// Verify checks the signature of an image and that data is the image
+func Verify(key ed25519.PublicKey, img Image, data []byte) error {
+	sum := sha256.Sum256(data)
+	if int64(len(data)) != img.Size || hex.EncodeToString(sum[:]) != img.SHA256 {
+		return fmt.Errorf("%w: the data is not the image", ErrInvalid)
+	}
+	signature, err := base64.StdEncoding.DecodeString(img.Signature)
+	if err != nil || !ed25519.Verify(key, SignedMessage(img), signature) {
+		return fmt.Errorf("%w: invalid signature", ErrInvalid)
+	}
+	return nil
+}
+
+// SigningKey is the public key images are signed with
+type SigningKey struct {
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"algorithm"`
+	// PublicKey is the PEM PKIX public key
+	PublicKey string `json:"public_key"`
+}
+
+// Store keeps the images
+type Store interface {
+	Has(key string) bool
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, data []byte) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Options configures a Manager
+type Options struct {
+	// Dir holds the signing key (signing-key.pem) and the images, rollouts
+	// and device statuses (firmware.json); empty keeps them in memory
+	// with a new key
+	Dir string
+	// Devices, when set, only offers updates to the devices registered
+	// with the device CA and not revoked, and puts them in the device
+	// groups of their tenants
+	Devices *devices.CA
+	// Audit receives the changes to images and rollouts and refused
+	// devices; nil uses the global audit logger
+	Audit *audit.AuditLogger
+	// Now returns the current time; nil uses time.Now
+	Now func() time.Time
+	// Logger defaults to slog.Default
+	Logger *slog.Logger
+}
+
+// state is what the manager persists
+type state struct {
+	Images   []Image                 `json:"images"`
+	Rollouts []Rollout               `json:"rollouts"`
+	Statuses map[string]DeviceStatus `json:"statuses"`
+}
+
+// Manager holds the images and rollouts and the status of each device
+type Manager struct {
+	opts  Options
+	key   ed25519.PrivateKey
+	keyID string
+
+	mu       sync.Mutex
+	store    Store
+	state    state
+	watchers []func(Manifest)
+	// cache holds the content of the images read last, for block-wise
+	// transfers
+	cache []cachedImage
+}
+
+type cachedImage struct {
+	id   string
+	data []byte
+}
+
+// cachedImages is how many images the cache holds
+const cachedImages = 4
+
+// New returns a Manager with the signing key and state in opts.Dir,
+// creating the key on first use
+func New(opts Options) (*Manager, error) {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	m := &Manager{opts: opts, state: state{Statuses: make(map[string]DeviceStatus)}}
+	if err := m.loadKey(); err != nil {
+		return nil, err
+	}
+	if opts.Dir != "" {
+		data, err := os.ReadFile(filepath.Join(opts.Dir, "firmware.json"))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, err
+		default:
+			if err := json.Unmarshal(data, &m.state); err != nil {
+				return nil, fmt.Errorf("firmware: invalid state: %w", err)
+			}
+			if m.state.Statuses == nil {
+				m.state.Statuses = make(map[string]DeviceStatus)
+			}
+		}
+	}
+	return m, nil
+}
+
+// Attach sets the store images are kept in
+func (m *Manager) Attach(store Store) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.store = store
+}
+
+// SigningKey returns the public key images are signed with
+func (m *Manager) SigningKey() SigningKey {
+	der, _ := x509.MarshalPKIXPublicKey(m.key.Public())
+	return SigningKey{
+		KeyID:     m.keyID,
+		Algorithm: "Ed25519",
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	}
+}
+
+// Images returns the images, oldest first
+func (m *Manager) Images() []Image {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.state.Images)
+}
+
+// Image returns an image
+func (m *Manager) Image(id string) (Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.imageLocked(id)
+	if i < 0 {
+		return Image{}, fmt.Errorf("%w: image %s", ErrNotFound, id)
+	}
+	return m.state.Images[i], nil
+}
+
+// AddImage stores and signs a firmware image
+func (m *Manager) AddImage(ctx context.Context, name, version string, data []byte, comment, actor string) (Image, error) {
+	switch {
+	case !validName.MatchString(name):
+		return Image{}, fmt.Errorf("%w: name must be 1 to 64 letters, digits, '.', '_', '+' or '-'", ErrInvalid)
+	case !validName.MatchString(version):
+		return Image{}, fmt.Errorf("%w: version must be 1 to 64 letters, digits, '.', '_', '+' or '-'", ErrInvalid)
+	case len(data) == 0:
+		return Image{}, fmt.Errorf("%w: empty image", ErrInvalid)
+	case len(data) > MaxImageSize:
+		return Image{}, fmt.Errorf("%w: images are up to %d bytes", ErrInvalid, MaxImageSize)
+	}
+	id, err := newID()
+	if err != nil {
+		return Image{}, err
+	}
+	sum := sha256.Sum256(data)
+	img := Image{
+		ID:        id,
+		Name:      name,
+		Version:   version,
+		Size:      int64(len(data)),
+		SHA256:    hex.EncodeToString(sum[:]),
+		KeyID:     m.keyID,
+		Comment:   comment,
+		CreatedAt: m.opts.Now().UTC(),
+	}
+	img.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(m.key, SignedMessage(img)))
+
+	m.mu.Lock()
+	store := m.store
+	taken := slices.ContainsFunc(m.state.Images, func(i Image) bool { return i.Name == name && i.Version == version })
+	m.mu.Unlock()
+	if taken {
+		return Image{}, fmt.Errorf("%w: %s %s", ErrExists, name, version)
+	}
+	if store == nil {
+		return Image{}, errors.New("firmware: no store attached")
+	}
+	if err := store.Put(ctx, Prefix+id, data); err != nil {
+		return Image{}, err
+	}
+
+	m.mu.Lock()
+	m.state.Images = append(m.state.Images, img)
+	err = m.saveLocked()
+	m.mu.Unlock()
+	m.auditChange(ctx, "firmware_image_add", actor, map[string]interface{}{"image": id, "name": name, "version": version, "sha256": img.SHA256})
+	return img, err
+}
+
+// DeleteImage deletes an image no rollout uses unless cancelled
+func (m *Manager) DeleteImage(ctx context.Context, id, actor string) error {
+	m.mu.Lock()
+	i := m.imageLocked(id)
+	if i < 0 {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: image %s", ErrNotFound, id)
+	}
+	if slices.ContainsFunc(m.state.Rollouts, func(r Rollout) bool { return r.ImageID == id && r.State != RolloutCancelled }) {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: a rollout releases image %s", ErrInUse, id)
+	}
+	m.state.Images = slices.Delete(m.state.Images, i, i+1)
+	m.cache = slices.DeleteFunc(m.cache, func(c cachedImage) bool { return c.id == id })
+	store := m.store
+	err := m.saveLocked()
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if store != nil {
+		if err := store.Delete(ctx, Prefix+id); err != nil {
+			m.opts.Logger.Warn("Failed to delete firmware image", "image", id, "error", err)
+		}
+	}
+	m.auditChange(ctx, "firmware_image_delete", actor, map[string]interface{}{"image": id})
+	return nil
+}
+
+// Content returns the data of an image
+func (m *Manager) Content(ctx context.Context, id string) ([]byte, error) {
+	m.mu.Lock()
+	if m.imageLocked(id) < 0 {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%w: image %s", ErrNotFound, id)
+	}
+	for _, c := range m.cache {
+		if c.id == id {
+			m.mu.Unlock()
+			return c.data, nil
+		}
+	}
+	store := m.store
+	m.mu.Unlock()
+	if store == nil {
+		return nil, errors.New("firmware: no store attached")
+	}
+	data, err := store.Get(ctx, Prefix+id)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cache = append(m.cache, cachedImage{id: id, data: data})
+	if len(m.cache) > cachedImages {
+		m.cache = m.cache[1:]
+	}
+	return data, nil
+}
+
+// Watch calls fn with the manifests of the devices a released ring
+// offers an update to, for transports pushing them
+func (m *Manager) Watch(fn func(Manifest)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.watchers = append(m.watchers, fn)
+}
+
+func (m *Manager) imageLocked(id string) int {
+	return slices.IndexFunc(m.state.Images, func(i Image) bool { return i.ID == id })
+}
+
+// loadKey loads the signing key, creating it if there is none
+func (m *Manager) loadKey() error {
+	path := filepath.Join(m.opts.Dir, "signing-key.pem")
+	if m.opts.Dir != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			block, _ := pem.Decode(data)
+			if block == nil {
+				return fmt.Errorf("firmware: no PEM key in %s", path)
+			}
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return fmt.Errorf("firmware: invalid key in %s: %w", path, err)
+			}
+			signer, ok := key.(ed25519.PrivateKey)
+			if !ok {
+				return fmt.Errorf("firmware: %s is not an Ed25519 key", path)
+			}
+			m.setKey(signer)
+			return nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	m.setKey(key)
+	if m.opts.Dir == "" {
+		return nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.opts.Dir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+}
+
+func (m *Manager) setKey(key ed25519.PrivateKey) {
+	m.key = key
+	sum := sha256.Sum256(key.Public().(ed25519.PublicKey))
+	m.keyID = hex.EncodeToString(sum[:8])
+}
+
+// saveLocked persists the state, if the manager has a directory
+func (m *Manager) saveLocked() error {
+	if m.opts.Dir == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(m.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.opts.Dir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(m.opts.Dir, "firmware.json")
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (m *Manager) auditLogger() *audit.AuditLogger {
+	if m.opts.Audit != nil {
+		return m.opts.Audit
+	}
+	return audit.GlobalAuditLogger
+}
+
+// auditChange records a change to images or rollouts
+func (m *Manager) auditChange(ctx context.Context, action, actor string, details map[string]interface{}) {
+	logger := m.auditLogger()
+	if logger == nil {
+		m.opts.Logger.Info("Firmware change", "action", action, "actor", actor, "details", details)
+		return
+	}
+	if err := logger.LogAdminEvent(ctx, actor, action, "success", details); err != nil {
+		m.opts.Logger.Warn("firmware: failed to write audit event", "error", err)
+	}
+}
+
+// auditDenied records a device refused an update
+func (m *Manager) auditDenied(ctx context.Context, device string, reason error) {
+	logger := m.auditLogger()
+	if logger == nil {
+		m.opts.Logger.Warn("Firmware update check denied", "device", device, "reason", reason)
+		return
+	}
+	event := &audit.AuditEvent{
+		Type:     audit.AuditEventTypeSecurity,
+		Level:    audit.AuditLevelWarning,
+		Resource: device,
+		Action:   "firmware_check",
+		Result:   "denied",
+		Message:  fmt.Sprintf("Firmware update check of %s denied: %v", device, reason),
+		Details:  map[string]interface{}{"device": device},
+		Source:   "firmware",
+		Category: "devices",
+		Tags:     []string{"firmware", "denied"},
+	}
+	if err := logger.LogEvent(ctx, event); err != nil {
+		m.opts.Logger.Warn("firmware: failed to write audit event", "error", err)
+	}
+}
+
+// trimVersion returns a version as devices report it, without spaces
+func trimVersion(v string) string {
+	return strings.TrimPrefix(strings.TrimSpace(v), "v")
+}
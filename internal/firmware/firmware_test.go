@@ -0,0 +1,235 @@
+package firmware
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skpow1234/Peervault/internal/devices"
+)
+
+// memStore keeps images in memory
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemStore() *memStore { return &memStore{objects: make(map[string][]byte)} }
+
+func (s *memStore) Has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.objects[key]
+	return ok
+}
+
+func (s *memStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return data, nil
+}
+
+func (s *memStore) Put(ctx context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	return nil
+}
+
+func (s *memStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func TestImages(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := newMemStore()
+	m, err := New(Options{Dir: dir})
+	require.NoError(t, err)
+	m.Attach(store)
+
+	data := []byte("firmware 1.1.0")
+	img, err := m.AddImage(ctx, "sensor", "1.1.0", data, "fixes", "admin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), img.Size)
+	assert.True(t, store.Has(Prefix+img.ID))
+	_, err = m.AddImage(ctx, "sensor", "1.1.0", data, "", "admin")
+	assert.ErrorIs(t, err, ErrExists)
+	_, err = m.AddImage(ctx, "sensor", "1.2.0", nil, "", "admin")
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, err = m.AddImage(ctx, "sensor", "1 2", data, "", "admin")
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	// Devices verify images with the signing key
+	block, _ := pem.Decode([]byte(m.SigningKey().PublicKey))
+	require.NotNil(t, block)
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	require.NoError(t, err)
+	assert.Equal(t, img.KeyID, m.SigningKey().KeyID)
+	require.NoError(t, Verify(key.(ed25519.PublicKey), img, data))
+	assert.ErrorIs(t, Verify(key.(ed25519.PublicKey), img, []byte("firmware 1.1.1")), ErrInvalid)
+	forged := img
+	forged.Version = "9.0.0"
+	assert.ErrorIs(t, Verify(key.(ed25519.PublicKey), forged, data), ErrInvalid)
+
+	content, err := m.Content(ctx, img.ID)
+	require.NoError(t, err)
+	assert.Equal(t, data, content)
+
+	// The key and images persist
+	m, err = New(Options{Dir: dir})
+	require.NoError(t, err)
+	m.Attach(store)
+	assert.Equal(t, img.KeyID, m.SigningKey().KeyID)
+	assert.Equal(t, []Image{img}, m.Images())
+
+	// Images of rollouts are deleted once these are cancelled
+	rollout, err := m.CreateRollout(ctx, RolloutRequest{ImageID: img.ID, Rings: []Ring{{Name: "all", Percent: 100}}}, "admin")
+	require.NoError(t, err)
+	assert.ErrorIs(t, m.DeleteImage(ctx, img.ID, "admin"), ErrInUse)
+	require.NoError(t, m.Cancel(ctx, rollout.ID, "admin"))
+	require.NoError(t, m.DeleteImage(ctx, img.ID, "admin"))
+	assert.False(t, store.Has(Prefix+img.ID))
+	_, err = m.Content(ctx, img.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRollout(t *testing.T) {
+	ctx := context.Background()
+	ca, err := devices.New(devices.Options{Dir: t.TempDir()})
+	require.NoError(t, err)
+	for i := range 20 {
+		_, _, err := ca.Register(ctx, fmt.Sprintf("sensor-%d", i), "plant-a", "", "admin")
+		require.NoError(t, err)
+	}
+	_, _, err = ca.Register(ctx, "canary", "", "", "admin")
+	require.NoError(t, err)
+	_, _, err = ca.Register(ctx, "gateway", "plant-b", "", "admin")
+	require.NoError(t, err)
+
+	m, err := New(Options{Devices: ca})
+	require.NoError(t, err)
+	m.Attach(newMemStore())
+	var mu sync.Mutex
+	announced := make(map[string]Manifest)
+	m.Watch(func(manifest Manifest) {
+		mu.Lock()
+		defer mu.Unlock()
+		announced[manifest.Device] = manifest
+	})
+
+	img, err := m.AddImage(ctx, "sensor", "2.0.0", []byte("firmware 2.0.0"), "", "admin")
+	require.NoError(t, err)
+	_, err = m.CreateRollout(ctx, RolloutRequest{ImageID: "missing", Rings: []Ring{{Name: "all", Percent: 100}}}, "admin")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = m.CreateRollout(ctx, RolloutRequest{ImageID: img.ID, Rings: []Ring{{Name: "none"}}}, "admin")
+	assert.ErrorIs(t, err, ErrInvalid)
+	rollout, err := m.CreateRollout(ctx, RolloutRequest{
+		ImageID: img.ID,
+		Rings: []Ring{
+			{Name: "canary", Devices: []string{"canary"}},
+			{Name: "plant-b", Tenants: []string{"plant-b"}},
+			{Name: "half", Percent: 50},
+			{Name: "all", Percent: 100},
+		},
+		FailureThreshold: 50,
+	}, "admin")
+	require.NoError(t, err)
+
+	// The first ring is the canary
+	manifest, err := m.Check(ctx, "canary", "1.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, rollout.ID, manifest.RolloutID)
+	assert.Equal(t, img, manifest.Image)
+	assert.Equal(t, "/fw/images/"+img.ID, manifest.CoAPPath)
+	assert.Equal(t, "firmware/canary/get", manifest.MQTTTopic)
+	_, err = m.Check(ctx, "gateway", "1.0.0")
+	assert.ErrorIs(t, err, ErrNoUpdate)
+	_, err = m.Check(ctx, "unknown", "1.0.0")
+	assert.ErrorIs(t, err, ErrDenied)
+	mu.Lock()
+	assert.Contains(t, announced, "canary")
+	assert.Len(t, announced, 1)
+	mu.Unlock()
+
+	status, err := m.Report(ctx, "canary", StatusReport{ImageID: img.ID, State: StateInstalled})
+	require.NoError(t, err)
+	assert.Equal(t, "2.0.0", status.Version)
+	_, err = m.Check(ctx, "canary", "2.0.0")
+	assert.ErrorIs(t, err, ErrNoUpdate)
+	_, err = m.Report(ctx, "gateway", StatusReport{ImageID: img.ID, State: StateInstalled})
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = m.Report(ctx, "canary", StatusReport{ImageID: img.ID, State: "done"})
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	// Then the device group of a tenant, then half of all devices
+	_, err = m.Advance(ctx, rollout.ID, "admin")
+	require.NoError(t, err)
+	_, err = m.Check(ctx, "gateway", "1.0.0")
+	require.NoError(t, err)
+	_, err = m.Advance(ctx, rollout.ID, "admin")
+	require.NoError(t, err)
+	var offered []string
+	for i := range 20 {
+		device := fmt.Sprintf("sensor-%d", i)
+		if _, err := m.Check(ctx, device, "1.0.0"); err == nil {
+			offered = append(offered, device)
+		} else {
+			assert.ErrorIs(t, err, ErrNoUpdate)
+		}
+	}
+	assert.NotEmpty(t, offered)
+	assert.Less(t, len(offered), 20)
+	mu.Lock()
+	for _, device := range offered {
+		assert.Contains(t, announced, device)
+	}
+	mu.Unlock()
+
+	// Failures past the threshold pause the rollout
+	_, err = m.Report(ctx, "gateway", StatusReport{ImageID: img.ID, State: StateFailed, Detail: "flash error"})
+	require.NoError(t, err)
+	current, statuses, err := m.Rollout(rollout.ID)
+	require.NoError(t, err)
+	assert.Equal(t, RolloutPaused, current.State)
+	assert.Equal(t, 1, current.Devices[StateFailed])
+	assert.Equal(t, 1, current.Devices[StateInstalled])
+	assert.Len(t, statuses, 2+len(offered))
+	_, err = m.Check(ctx, offered[0], "1.0.0")
+	assert.ErrorIs(t, err, ErrNoUpdate)
+
+	_, err = m.Resume(ctx, rollout.ID, "admin")
+	require.NoError(t, err)
+	_, err = m.Check(ctx, offered[0], "1.0.0")
+	require.NoError(t, err)
+	_, err = m.Check(ctx, "gateway", "1.0.0")
+	assert.ErrorIs(t, err, ErrNoUpdate, "devices that failed are not offered the image again")
+	_, err = m.Advance(ctx, rollout.ID, "admin")
+	require.NoError(t, err)
+	_, err = m.Advance(ctx, rollout.ID, "admin")
+	assert.ErrorIs(t, err, ErrInvalid)
+	for i := range 20 {
+		_, err := m.Check(ctx, fmt.Sprintf("sensor-%d", i), "1.0.0")
+		require.NoError(t, err)
+	}
+
+	// Revoked devices are refused
+	require.NoError(t, ca.Revoke(ctx, "sensor-0", "admin"))
+	_, err = m.Check(ctx, "sensor-0", "1.0.0")
+	assert.ErrorIs(t, err, ErrDenied)
+}
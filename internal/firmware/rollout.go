@@ -0,0 +1,534 @@
+package firmware
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RolloutState is the state of a rollout
+type RolloutState string
+
+// The states of a rollout. Only active rollouts offer their image; paused
+// rollouts may resume, cancelled rollouts may not.
+const (
+	RolloutActive    RolloutState = "active"
+	RolloutPaused    RolloutState = "paused"
+	RolloutCancelled RolloutState = "cancelled"
+)
+
+// InstallState is how far a device got installing an image
+type InstallState string
+
+// The install states of a device; devices report all but offered, which
+// is theirs once an update is offered to them
+const (
+	StateOffered     InstallState = "offered"
+	StateDownloading InstallState = "downloading"
+	StateInstalling  InstallState = "installing"
+	StateInstalled   InstallState = "installed"
+	StateFailed      InstallState = "failed"
+)
+
+// MQTTBlockSize is how many bytes of an image each MQTT block carries
+const MQTTBlockSize = 512
+
+// Ring is a stage of a rollout. Rings are cumulative: releasing one keeps
+// releasing to the devices of the rings before it.
+type Ring struct {
+	Name string `json:"name"`
+	// Percent releases to a share of all devices, the same devices for as
+	// long as the rollout lasts
+	Percent int `json:"percent,omitempty"`
+	// Tenants releases to the device groups of tenants
+	Tenants []string `json:"tenants,omitempty"`
+	// Devices releases to devices by ID
+	Devices []string `json:"devices,omitempty"`
+}
+
+// Rollout releases an image to devices ring by ring
+type Rollout struct {
+	ID      string `json:"id"`
+	ImageID string `json:"image_id"`
+	Rings   []Ring `json:"rings"`
+	// Released is how many rings are released
+	Released int          `json:"released"`
+	State    RolloutState `json:"state"`
+	// Reason is why the rollout was paused
+	Reason string `json:"reason,omitempty"`
+	// FailureThreshold pauses the rollout once that percentage of the
+	// devices done installing failed; 0 never pauses it
+	FailureThreshold int       `json:"failure_threshold,omitempty"`
+	Comment          string    `json:"comment,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// RolloutRequest creates a rollout
+type RolloutRequest struct {
+	ImageID          string `json:"image_id"`
+	Rings            []Ring `json:"rings"`
+	FailureThreshold int    `json:"failure_threshold,omitempty"`
+	Comment          string `json:"comment,omitempty"`
+}
+
+// RolloutStatus is a rollout with how many devices are in each state
+type RolloutStatus struct {
+	Rollout
+	Devices map[InstallState]int `json:"devices"`
+}
+
+// DeviceStatus is where a device is installing the image of a rollout
+type DeviceStatus struct {
+	Device    string       `json:"device"`
+	RolloutID string       `json:"rollout_id"`
+	ImageID   string       `json:"image_id"`
+	State     InstallState `json:"state"`
+	Detail    string       `json:"detail,omitempty"`
+	// Version is the version the device last said it runs
+	Version   string    `json:"version,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// StatusReport is a device reporting how an installation goes
+type StatusReport struct {
+	ImageID string       `json:"image_id"`
+	State   InstallState `json:"state"`
+	Detail  string       `json:"detail,omitempty"`
+}
+
+// Manifest offers an update to a device: the image, signed, and where to
+// download it over each transport
+type Manifest struct {
+	Device    string `json:"device"`
+	RolloutID string `json:"rollout_id"`
+	Image     Image  `json:"image"`
+	// HTTPPath is the REST API path of the image content
+	HTTPPath string `json:"http_path"`
+	// CoAPPath is the CoAP path of the image, transferred block-wise
+	CoAPPath string `json:"coap_path"`
+	// MQTTTopic is where the device asks for blocks of MQTTBlockSize
+	// bytes, see the package mqtt
+	MQTTTopic     string `json:"mqtt_topic"`
+	MQTTBlockSize int    `json:"mqtt_block_size"`
+}
+
+// Rollouts returns the rollouts, oldest first
+func (m *Manager) Rollouts() []RolloutStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rollouts := make([]RolloutStatus, len(m.state.Rollouts))
+	for i, r := range m.state.Rollouts {
+		rollouts[i] = m.statusLocked(r)
+	}
+	return rollouts
+}
+
+// Rollout returns a rollout and the status of its devices
+func (m *Manager) Rollout(id string) (RolloutStatus, []DeviceStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.rolloutLocked(id)
+	if i < 0 {
+		return RolloutStatus{}, nil, fmt.Errorf("%w: rollout %s", ErrNotFound, id)
+	}
+	var statuses []DeviceStatus
+	for _, s := range m.state.Statuses {
+		if s.RolloutID == id {
+			statuses = append(statuses, s)
+		}
+	}
+	slices.SortFunc(statuses, func(a, b DeviceStatus) int { return strings.Compare(a.Device, b.Device) })
+	return m.statusLocked(m.state.Rollouts[i]), statuses, nil
+}
+
+// CreateRollout creates a rollout of an image and releases its first ring
+func (m *Manager) CreateRollout(ctx context.Context, req RolloutRequest, actor string) (Rollout, error) {
+	if len(req.Rings) == 0 {
+		return Rollout{}, fmt.Errorf("%w: a rollout needs rings", ErrInvalid)
+	}
+	if req.FailureThreshold < 0 || req.FailureThreshold > 100 {
+		return Rollout{}, fmt.Errorf("%w: the failure threshold is a percentage", ErrInvalid)
+	}
+	for i, ring := range req.Rings {
+		switch {
+		case ring.Name == "":
+			return Rollout{}, fmt.Errorf("%w: ring %d has no name", ErrInvalid, i)
+		case slices.ContainsFunc(req.Rings[:i], func(r Ring) bool { return r.Name == ring.Name }):
+			return Rollout{}, fmt.Errorf("%w: two rings are named %s", ErrInvalid, ring.Name)
+		case ring.Percent < 0 || ring.Percent > 100:
+			return Rollout{}, fmt.Errorf("%w: ring %s releases to %d percent of devices", ErrInvalid, ring.Name, ring.Percent)
+		case ring.Percent == 0 && len(ring.Tenants) == 0 && len(ring.Devices) == 0:
+			return Rollout{}, fmt.Errorf("%w: ring %s releases to no devices", ErrInvalid, ring.Name)
+		}
+	}
+	id, err := newID()
+	if err != nil {
+		return Rollout{}, err
+	}
+	now := m.opts.Now().UTC()
+	rollout := Rollout{
+		ID:               id,
+		ImageID:          req.ImageID,
+		Rings:            req.Rings,
+		Released:         1,
+		State:            RolloutActive,
+		FailureThreshold: req.FailureThreshold,
+		Comment:          req.Comment,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+
+	m.mu.Lock()
+	if m.imageLocked(req.ImageID) < 0 {
+		m.mu.Unlock()
+		return Rollout{}, fmt.Errorf("%w: image %s", ErrNotFound, req.ImageID)
+	}
+	m.state.Rollouts = append(m.state.Rollouts, rollout)
+	err = m.saveLocked()
+	m.mu.Unlock()
+	if err != nil {
+		return Rollout{}, err
+	}
+	m.auditChange(ctx, "firmware_rollout_create", actor, map[string]interface{}{"rollout": id, "image": req.ImageID, "ring": req.Rings[0].Name})
+	m.announce(ctx)
+	return rollout, nil
+}
+
+// Advance releases the next ring of a rollout
+func (m *Manager) Advance(ctx context.Context, id, actor string) (Rollout, error) {
+	rollout, err := m.updateRollout(id, func(r *Rollout) error {
+		switch {
+		case r.State == RolloutCancelled:
+			return fmt.Errorf("%w: rollout %s is cancelled", ErrInvalid, id)
+		case r.Released == len(r.Rings):
+			return fmt.Errorf("%w: every ring of rollout %s is released", ErrInvalid, id)
+		}
+		r.Released++
+		return nil
+	})
+	if err != nil {
+		return Rollout{}, err
+	}
+	m.auditChange(ctx, "firmware_rollout_advance", actor, map[string]interface{}{"rollout": id, "ring": rollout.Rings[rollout.Released-1].Name})
+	m.announce(ctx)
+	return rollout, nil
+}
+
+// Pause stops a rollout offering its image
+func (m *Manager) Pause(ctx context.Context, id, reason, actor string) (Rollout, error) {
+	rollout, err := m.updateRollout(id, func(r *Rollout) error {
+		if r.State == RolloutCancelled {
+			return fmt.Errorf("%w: rollout %s is cancelled", ErrInvalid, id)
+		}
+		r.State, r.Reason = RolloutPaused, reason
+		return nil
+	})
+	if err != nil {
+		return Rollout{}, err
+	}
+	m.auditChange(ctx, "firmware_rollout_pause", actor, map[string]interface{}{"rollout": id, "reason": reason})
+	return rollout, nil
+}
+
+// Resume lets a paused rollout offer its image again
+func (m *Manager) Resume(ctx context.Context, id, actor string) (Rollout, error) {
+	rollout, err := m.updateRollout(id, func(r *Rollout) error {
+		if r.State == RolloutCancelled {
+			return fmt.Errorf("%w: rollout %s is cancelled", ErrInvalid, id)
+		}
+		r.State, r.Reason = RolloutActive, ""
+		return nil
+	})
+	if err != nil {
+		return Rollout{}, err
+	}
+	m.auditChange(ctx, "firmware_rollout_resume", actor, map[string]interface{}{"rollout": id})
+	m.announce(ctx)
+	return rollout, nil
+}
+
+// Cancel ends a rollout for good, which lets its image be deleted
+func (m *Manager) Cancel(ctx context.Context, id, actor string) error {
+	_, err := m.updateRollout(id, func(r *Rollout) error {
+		r.State, r.Reason = RolloutCancelled, ""
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	m.auditChange(ctx, "firmware_rollout_cancel", actor, map[string]interface{}{"rollout": id})
+	return nil
+}
+
+// Check returns the update offered to a device running a version, or
+// ErrNoUpdate. The newest active rollout whose released rings include the
+// device offers its image until the device installed it or failed to.
+func (m *Manager) Check(ctx context.Context, device, version string) (Manifest, error) {
+	tenant, err := m.device(ctx, device)
+	if err != nil {
+		return Manifest{}, err
+	}
+	m.mu.Lock()
+	manifest, ok := m.offerLocked(device, tenant, version)
+	err = m.saveLocked()
+	m.mu.Unlock()
+	if !ok {
+		return Manifest{}, ErrNoUpdate
+	}
+	return manifest, err
+}
+
+// Report records how a device installing an image is doing, pausing the
+// rollout when too many devices failed
+func (m *Manager) Report(ctx context.Context, device string, report StatusReport) (DeviceStatus, error) {
+	switch report.State {
+	case StateDownloading, StateInstalling, StateInstalled, StateFailed:
+	default:
+		return DeviceStatus{}, fmt.Errorf("%w: unknown state %q", ErrInvalid, report.State)
+	}
+	tenant, err := m.device(ctx, device)
+	if err != nil {
+		return DeviceStatus{}, err
+	}
+
+	m.mu.Lock()
+	status, ok := m.state.Statuses[device]
+	if !ok || status.ImageID != report.ImageID {
+		// The newest rollout of the image releasing to the device
+		rollouts := m.newestRolloutsLocked()
+		i := slices.IndexFunc(rollouts, func(r Rollout) bool {
+			return r.ImageID == report.ImageID && r.State != RolloutCancelled && r.targets(device, tenant)
+		})
+		if i < 0 {
+			m.mu.Unlock()
+			return DeviceStatus{}, fmt.Errorf("%w: no rollout of image %s releases to %s", ErrNotFound, report.ImageID, device)
+		}
+		rollout := rollouts[i]
+		status = DeviceStatus{Device: device, RolloutID: rollout.ID, ImageID: rollout.ImageID}
+	}
+	status.State, status.Detail, status.UpdatedAt = report.State, report.Detail, m.opts.Now().UTC()
+	if report.State == StateInstalled {
+		if img := m.imageLocked(status.ImageID); img >= 0 {
+			status.Version = m.state.Images[img].Version
+		}
+	}
+	m.state.Statuses[device] = status
+	var paused *Rollout
+	if report.State == StateFailed {
+		paused = m.checkFailuresLocked(status.RolloutID)
+	}
+	err = m.saveLocked()
+	m.mu.Unlock()
+	if paused != nil {
+		m.auditChange(ctx, "firmware_rollout_pause", "firmware", map[string]interface{}{"rollout": paused.ID, "reason": paused.Reason})
+		m.opts.Logger.Warn("Firmware rollout paused", "rollout", paused.ID, "reason", paused.Reason)
+	}
+	return status, err
+}
+
+// Status returns the status of a device
+func (m *Manager) Status(device string) (DeviceStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status, ok := m.state.Statuses[device]
+	if !ok {
+		return DeviceStatus{}, fmt.Errorf("%w: no status of %s", ErrNotFound, device)
+	}
+	return status, nil
+}
+
+// checkFailuresLocked pauses a rollout whose failure threshold its
+// devices reached, returning it if so
+func (m *Manager) checkFailuresLocked(id string) *Rollout {
+	i := m.rolloutLocked(id)
+	if i < 0 {
+		return nil
+	}
+	rollout := &m.state.Rollouts[i]
+	if rollout.FailureThreshold == 0 || rollout.State != RolloutActive {
+		return nil
+	}
+	counts := m.countLocked(id)
+	done := counts[StateInstalled] + counts[StateFailed]
+	if counts[StateFailed]*100 < rollout.FailureThreshold*done {
+		return nil
+	}
+	rollout.State = RolloutPaused
+	rollout.Reason = fmt.Sprintf("%d of %d devices failed to install", counts[StateFailed], done)
+	rollout.UpdatedAt = m.opts.Now().UTC()
+	paused := *rollout
+	return &paused
+}
+
+// offerLocked returns the manifest of the update offered to a device and
+// records the offer
+func (m *Manager) offerLocked(device, tenant, version string) (Manifest, bool) {
+	now := m.opts.Now().UTC()
+	status, known := m.state.Statuses[device]
+	if version != "" && known {
+		status.Version = version
+		m.state.Statuses[device] = status
+	}
+	rollouts := m.newestRolloutsLocked()
+	i := slices.IndexFunc(rollouts, func(r Rollout) bool {
+		return r.State == RolloutActive && r.targets(device, tenant)
+	})
+	if i < 0 {
+		return Manifest{}, false
+	}
+	rollout := rollouts[i]
+	img := m.imageLocked(rollout.ImageID)
+	if img < 0 {
+		return Manifest{}, false
+	}
+	image := m.state.Images[img]
+	if version == "" && known {
+		version = status.Version
+	}
+
+	switch {
+	case version != "" && trimVersion(version) == trimVersion(image.Version):
+		// The device runs the image already
+		if !known || status.RolloutID != rollout.ID || status.State != StateInstalled {
+			m.state.Statuses[device] = DeviceStatus{Device: device, RolloutID: rollout.ID, ImageID: image.ID, State: StateInstalled, Version: version, UpdatedAt: now}
+		}
+		return Manifest{}, false
+	case known && status.RolloutID == rollout.ID && (status.State == StateInstalled || status.State == StateFailed):
+		return Manifest{}, false
+	case !known || status.RolloutID != rollout.ID:
+		m.state.Statuses[device] = DeviceStatus{Device: device, RolloutID: rollout.ID, ImageID: image.ID, State: StateOffered, Version: version, UpdatedAt: now}
+	}
+	return Manifest{
+		Device:        device,
+		RolloutID:     rollout.ID,
+		Image:         image,
+		HTTPPath:      HTTPPath + "/images/" + image.ID + "/content",
+		CoAPPath:      CoAPPath + "/images/" + image.ID,
+		MQTTTopic:     TopicPrefix + device + "/get",
+		MQTTBlockSize: MQTTBlockSize,
+	}, true
+}
+
+// announce passes the updates offered to the devices the manager knows of
+// to the watchers, after a ring was released
+func (m *Manager) announce(ctx context.Context) {
+	m.mu.Lock()
+	watchers := slices.Clone(m.watchers)
+	m.mu.Unlock()
+	if len(watchers) == 0 {
+		return
+	}
+
+	tenants := make(map[string]string)
+	if m.opts.Devices != nil {
+		for _, d := range m.opts.Devices.Devices() {
+			if d.RevokedAt == nil {
+				tenants[d.ID] = d.Tenant
+			}
+		}
+	}
+	var manifests []Manifest
+	m.mu.Lock()
+	if m.opts.Devices == nil {
+		for device := range m.state.Statuses {
+			tenants[device] = ""
+		}
+	}
+	for device, tenant := range tenants {
+		if manifest, ok := m.offerLocked(device, tenant, ""); ok {
+			manifests = append(manifests, manifest)
+		}
+	}
+	if err := m.saveLocked(); err != nil {
+		m.opts.Logger.Warn("Failed to save firmware state", "error", err)
+	}
+	m.mu.Unlock()
+
+	for _, manifest := range manifests {
+		if ctx.Err() != nil {
+			return
+		}
+		for _, watch := range watchers {
+			watch(manifest)
+		}
+	}
+}
+
+// device returns the tenant of a device allowed updates
+func (m *Manager) device(ctx context.Context, id string) (string, error) {
+	if !validName.MatchString(id) {
+		return "", fmt.Errorf("%w: invalid device %q", ErrInvalid, id)
+	}
+	if m.opts.Devices == nil {
+		return "", nil
+	}
+	device, err := m.opts.Devices.Device(id)
+	if err == nil && device.RevokedAt != nil {
+		err = fmt.Errorf("device %s is revoked", id)
+	}
+	if err != nil {
+		m.auditDenied(ctx, id, err)
+		return "", fmt.Errorf("%w: %v", ErrDenied, err)
+	}
+	return device.Tenant, nil
+}
+
+// updateRollout changes a rollout and persists it
+func (m *Manager) updateRollout(id string, change func(*Rollout) error) (Rollout, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.rolloutLocked(id)
+	if i < 0 {
+		return Rollout{}, fmt.Errorf("%w: rollout %s", ErrNotFound, id)
+	}
+	rollout := m.state.Rollouts[i]
+	if err := change(&rollout); err != nil {
+		return Rollout{}, err
+	}
+	rollout.UpdatedAt = m.opts.Now().UTC()
+	m.state.Rollouts[i] = rollout
+	return rollout, m.saveLocked()
+}
+
+func (m *Manager) rolloutLocked(id string) int {
+	return slices.IndexFunc(m.state.Rollouts, func(r Rollout) bool { return r.ID == id })
+}
+
+// newestRolloutsLocked returns the rollouts, newest first
+func (m *Manager) newestRolloutsLocked() []Rollout {
+	rollouts := slices.Clone(m.state.Rollouts)
+	slices.Reverse(rollouts)
+	return rollouts
+}
+
+func (m *Manager) statusLocked(r Rollout) RolloutStatus {
+	return RolloutStatus{Rollout: r, Devices: m.countLocked(r.ID)}
+}
+
+func (m *Manager) countLocked(id string) map[InstallState]int {
+	counts := make(map[InstallState]int)
+	for _, s := range m.state.Statuses {
+		if s.RolloutID == id {
+			counts[s.State]++
+		}
+	}
+	return counts
+}
+
+// targets reports whether the released rings of a rollout include a
+// device of a tenant
+func (r Rollout) targets(device, tenant string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(r.ID + "/" + device))
+	bucket := int(h.Sum32() % 100)
+	for _, ring := range r.Rings[:r.Released] {
+		if bucket < ring.Percent || slices.Contains(ring.Devices, device) || (tenant != "" && slices.Contains(ring.Tenants, tenant)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package geo
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+)
+
+// DB locates IP addresses. Networks added with AddNetwork take precedence
+// over the ranges of a loaded database, the most specific first, so an
+// operator can label private networks a public database does not know.
+type DB struct {
+	ranges   []ipRange
+	networks []network
+}
+
+type ipRange struct {
+	start, end netip.Addr
+	loc        Location
+}
+
+type network struct {
+	prefix netip.Prefix
+	loc    Location
+}
+
+// NewDB returns an empty database
+func NewDB() *DB { return &DB{} }
+
+// OpenCSV loads a database from a CSV file; see LoadCSV
+func OpenCSV(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	db, err := LoadCSV(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+// LoadCSV reads a database whose rows are
+//
+//	network,country,region,latitude,longitude
+//
+// where network is a CIDR prefix or a start-end range of addresses, and
+// latitude and longitude may be empty for rows known by region only.
+// Lines starting with # are comments. Ranges must not overlap.
+func LoadCSV(r io.Reader) (*DB, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 5
+	cr.TrimLeadingSpace = true
+	db := NewDB()
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("geo: %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+		start, end, err := parseNetwork(row[0])
+		if err != nil {
+			return nil, fmt.Errorf("geo: line %d: %w", line, err)
+		}
+		loc := Location{Country: row[1], Region: row[2]}
+		if row[3] != "" || row[4] != "" {
+			if loc.Point, err = ParsePoint(row[3] + "," + row[4]); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			loc.HasPoint = true
+		}
+		db.ranges = append(db.ranges, ipRange{start: start, end: end, loc: loc})
+	}
+	slices.SortFunc(db.ranges, func(a, b ipRange) int { return a.start.Compare(b.start) })
+	for i := 1; i < len(db.ranges); i++ {
+		if db.ranges[i].start.Compare(db.ranges[i-1].end) <= 0 {
+			return nil, fmt.Errorf("geo: range starting at %s overlaps the one before", db.ranges[i].start)
+		}
+	}
+	return db, nil
+}
+
+// parseNetwork returns the first and last address of a CIDR prefix or a
+// start-end range
+func parseNetwork(s string) (netip.Addr, netip.Addr, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Addr{}, netip.Addr{}, err
+		}
+		return lastAddr(prefix.Masked())
+	}
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("network %q is neither a prefix nor a range", s)
+	}
+	start, err := netip.ParseAddr(strings.TrimSpace(from))
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, err
+	}
+	end, err := netip.ParseAddr(strings.TrimSpace(to))
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, err
+	}
+	start, end = start.Unmap(), end.Unmap()
+	if start.Is4() != end.Is4() || end.Less(start) {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid range %q", s)
+	}
+	return start, end, nil
+}
+
+// lastAddr returns the first and last address of a masked prefix
+func lastAddr(prefix netip.Prefix) (netip.Addr, netip.Addr, error) {
+	start := prefix.Addr().Unmap()
+	b := start.AsSlice()
+	bits := prefix.Bits()
+	if start.Is4() && prefix.Addr().Is4In6() {
+		bits -= 96
+	}
+	for i := range b {
+		for bit := 0; bit < 8; bit++ {
+			if i*8+bit >= bits {
+				b[i] |= 0x80 >> bit
+			}
+		}
+	}
+	end, _ := netip.AddrFromSlice(b)
+	return start, end, nil
+}
+
+// AddNetwork locates the addresses of prefix at loc
+func (db *DB) AddNetwork(prefix netip.Prefix, loc Location) {
+	db.networks = append(db.networks, network{prefix: prefix.Masked(), loc: loc})
+	slices.SortStableFunc(db.networks, func(a, b network) int { return b.prefix.Bits() - a.prefix.Bits() })
+}
+
+// Lookup returns the location of addr
+func (db *DB) Lookup(addr netip.Addr) (Location, bool) {
+	if db == nil || !addr.IsValid() {
+		return Location{}, false
+	}
+	addr = addr.Unmap()
+	for _, n := range db.networks {
+		if n.prefix.Contains(addr) {
+			return n.loc, true
+		}
+	}
+	i, found := slices.BinarySearchFunc(db.ranges, addr, func(r ipRange, a netip.Addr) int { return r.start.Compare(a) })
+	if !found {
+		i--
+	}
+	if i < 0 || db.ranges[i].end.Compare(addr) < 0 || db.ranges[i].start.Is4() != addr.Is4() {
+		return Location{}, false
+	}
+	return db.ranges[i].loc, true
+}
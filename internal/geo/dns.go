@@ -0,0 +1,226 @@
+package geo
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultDNSTTL is the TTL of DNS answers when DNSOptions.TTL is zero.
+// Answers depend on which nodes are healthy, so they are short-lived.
+const DefaultDNSTTL = 30 * time.Second
+
+// maxDNSPacket is the largest query read
+const maxDNSPacket = 4096
+
+// optionClientSubnet is the EDNS Client Subnet option code (RFC 7871)
+const optionClientSubnet = 8
+
+// DNSOptions configures a DNSServer
+type DNSOptions struct {
+	// Name is the name answered for, such as files.example.com; the DNS
+	// zone delegates it to the nodes
+	Name   string
+	TTL    time.Duration
+	Router *Router
+	Logger *slog.Logger
+}
+
+// DNSServer answers A and AAAA queries for one name with the address of
+// the node nearest to the client, and TXT queries with why it was chosen.
+// Clients are located by the subnet their resolver forwards in EDNS Client
+// Subnet, or else by the resolver's own address.
+type DNSServer struct {
+	opts DNSOptions
+	name dnsmessage.Name
+}
+
+// NewDNSServer creates a DNS server, which ServeUDP runs
+func NewDNSServer(opts DNSOptions) (*DNSServer, error) {
+	if opts.Router == nil {
+		return nil, errors.New("geo: DNS server needs a router")
+	}
+	if !strings.HasSuffix(opts.Name, ".") {
+		opts.Name += "."
+	}
+	name, err := dnsmessage.NewName(strings.ToLower(opts.Name))
+	if err != nil || opts.Name == "." {
+		return nil, fmt.Errorf("geo: invalid DNS name %q", opts.Name)
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultDNSTTL
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return &DNSServer{opts: opts, name: name}, nil
+}
+
+// ServeUDP answers the queries received on conn until ctx is done
+func (s *DNSServer) ServeUDP(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	buf := make([]byte, maxDNSPacket)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		var client netip.Addr
+		if udp, ok := from.(*net.UDPAddr); ok {
+			client = udp.AddrPort().Addr()
+		}
+		reply := s.answer(ctx, buf[:n], client)
+		if reply == nil {
+			continue
+		}
+		if _, err := conn.WriteTo(reply, from); err != nil {
+			s.opts.Logger.Debug("GeoDNS send failed", "error", err)
+		}
+	}
+}
+
+// answer returns the response to a query from client, nil for packets not
+// worth answering
+func (s *DNSServer) answer(ctx context.Context, packet []byte, client netip.Addr) []byte {
+	var p dnsmessage.Parser
+	header, err := p.Start(packet)
+	if err != nil || header.Response {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil
+	}
+	if err := p.SkipAllQuestions(); err == nil {
+		_ = p.SkipAllAnswers()
+		_ = p.SkipAllAuthorities()
+		if subnet, ok := clientSubnet(&p); ok {
+			client = subnet
+		}
+	}
+
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: header.ID, Response: true, Authoritative: true, RecursionDesired: header.RecursionDesired},
+		Questions: []dnsmessage.Question{q},
+	}
+	switch {
+	case header.OpCode != 0:
+		msg.RCode = dnsmessage.RCodeNotImplemented
+	case q.Class != dnsmessage.ClassINET || !strings.EqualFold(q.Name.String(), s.name.String()):
+		msg.Authoritative = false
+		msg.RCode = dnsmessage.RCodeRefused
+	default:
+		msg.Answers = s.records(ctx, q, client)
+	}
+	reply, err := msg.Pack()
+	if err != nil {
+		s.opts.Logger.Error("Failed to build GeoDNS response", "error", err)
+		return nil
+	}
+	return reply
+}
+
+// records returns the answers to q for client, none when the nearest node
+// has no address of the type asked
+func (s *DNSServer) records(ctx context.Context, q dnsmessage.Question, client netip.Addr) []dnsmessage.Resource {
+	choice, ok := s.opts.Router.Choose(client)
+	if !ok {
+		return nil
+	}
+	header := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET, TTL: uint32(s.opts.TTL / time.Second)}
+	switch q.Type {
+	case dnsmessage.TypeTXT:
+		return []dnsmessage.Resource{{Header: header, Body: &dnsmessage.TXTResource{TXT: []string{hint(choice)}}}}
+	case dnsmessage.TypeA, dnsmessage.TypeAAAA:
+		var answers []dnsmessage.Resource
+		for _, addr := range nodeAddrs(ctx, choice.Node) {
+			switch {
+			case q.Type == dnsmessage.TypeA && addr.Is4():
+				answers = append(answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: addr.As4()}})
+			case q.Type == dnsmessage.TypeAAAA && addr.Is6():
+				answers = append(answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AAAAResource{AAAA: addr.As16()}})
+			}
+		}
+		return answers
+	}
+	return nil
+}
+
+// hint describes a choice in a TXT record
+func hint(c Choice) string {
+	s := "node=" + c.Node.ID + " reason=" + c.Reason
+	if c.Node.Region != "" {
+		s += " region=" + c.Node.Region
+	}
+	if c.Node.URL != "" {
+		s += " url=" + c.Node.URL
+	}
+	return s
+}
+
+// nodeAddrs returns the addresses of the host of a node's URL
+func nodeAddrs(ctx context.Context, n Node) []netip.Addr {
+	u, err := url.Parse(n.URL)
+	if err != nil || u.Hostname() == "" {
+		return nil
+	}
+	if addr, err := netip.ParseAddr(u.Hostname()); err == nil {
+		return []netip.Addr{addr.Unmap()}
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return nil
+	}
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+	return addrs
+}
+
+// clientSubnet returns the address of the EDNS Client Subnet option of a
+// query whose parser is at the additional section
+func clientSubnet(p *dnsmessage.Parser) (netip.Addr, bool) {
+	for {
+		r, err := p.Additional()
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		opt, ok := r.Body.(*dnsmessage.OPTResource)
+		if !ok {
+			continue
+		}
+		for _, o := range opt.Options {
+			if o.Code != optionClientSubnet || len(o.Data) < 4 {
+				continue
+			}
+			family, address := binary.BigEndian.Uint16(o.Data), o.Data[4:]
+			switch {
+			case family == 1 && len(address) <= 4:
+				var b [4]byte
+				copy(b[:], address)
+				return netip.AddrFrom4(b), true
+			case family == 2 && len(address) <= 16:
+				var b [16]byte
+				copy(b[:], address)
+				return netip.AddrFrom16(b).Unmap(), true
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Package geo routes clients of a multi-region cluster to the nearest
+// node. Clients are located by IP address in a GeoIP database or a table
+// of the operator's networks; nodes advertise their region and location
+// to their peers. The API gateway redirects requests with HTTP 307 and a
+// small DNS server answers with the address of the nearest node.
+package geo
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// earthRadius is the mean radius of the Earth in kilometres
+const earthRadius = 6371.0
+
+// Point is a position on Earth in decimal degrees
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// ParsePoint parses a "latitude,longitude" pair
+func ParsePoint(s string) (Point, error) {
+	lat, lon, ok := strings.Cut(s, ",")
+	if !ok {
+		return Point{}, fmt.Errorf("geo: location %q is not latitude,longitude", s)
+	}
+	var p Point
+	var err error
+	if p.Lat, err = strconv.ParseFloat(strings.TrimSpace(lat), 64); err != nil || p.Lat < -90 || p.Lat > 90 {
+		return Point{}, fmt.Errorf("geo: invalid latitude in %q", s)
+	}
+	if p.Lon, err = strconv.ParseFloat(strings.TrimSpace(lon), 64); err != nil || p.Lon < -180 || p.Lon > 180 {
+		return Point{}, fmt.Errorf("geo: invalid longitude in %q", s)
+	}
+	return p, nil
+}
+
+func (p Point) String() string {
+	return strconv.FormatFloat(p.Lat, 'f', -1, 64) + "," + strconv.FormatFloat(p.Lon, 'f', -1, 64)
+}
+
+// Distance returns the great-circle distance between two points in
+// kilometres
+func Distance(a, b Point) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Location is where a client or node is. Locations may be known by region
+// only, without a point.
+type Location struct {
+	Country string `json:"country,omitempty"`
+	Region  string `json:"region,omitempty"`
+	Point   Point  `json:"point"`
+	// HasPoint is false when Point is unknown
+	HasPoint bool `json:"has_point"`
+}
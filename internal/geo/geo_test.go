@@ -0,0 +1,198 @@
+package geo
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const testDB = `# network,country,region,latitude,longitude
+203.0.113.0/25,DE,eu-central,50.11,8.68
+203.0.113.128-203.0.113.255,US,us-east,40.71,-74.01
+2001:db8::/32,JP,ap-northeast,,
+`
+
+var (
+	frankfurt = Point{Lat: 50.11, Lon: 8.68}
+	newYork   = Point{Lat: 40.71, Lon: -74.01}
+	tokyo     = Point{Lat: 35.68, Lon: 139.69}
+)
+
+func TestDistance(t *testing.T) {
+	assert.InDelta(t, 6200, Distance(frankfurt, newYork), 50)
+	assert.Zero(t, Distance(tokyo, tokyo))
+
+	p, err := ParsePoint("35.68, 139.69")
+	require.NoError(t, err)
+	assert.Equal(t, tokyo, p)
+	_, err = ParsePoint("91,0")
+	assert.Error(t, err)
+}
+
+func TestDBLookup(t *testing.T) {
+	db, err := LoadCSV(strings.NewReader(testDB))
+	require.NoError(t, err)
+
+	loc, ok := db.Lookup(netip.MustParseAddr("203.0.113.10"))
+	require.True(t, ok)
+	assert.Equal(t, "eu-central", loc.Region)
+	assert.True(t, loc.HasPoint)
+
+	loc, ok = db.Lookup(netip.MustParseAddr("::ffff:203.0.113.200"))
+	require.True(t, ok)
+	assert.Equal(t, "us-east", loc.Region)
+
+	loc, ok = db.Lookup(netip.MustParseAddr("2001:db8::1"))
+	require.True(t, ok)
+	assert.Equal(t, "ap-northeast", loc.Region)
+	assert.False(t, loc.HasPoint)
+
+	_, ok = db.Lookup(netip.MustParseAddr("198.51.100.1"))
+	assert.False(t, ok)
+
+	// Operator networks win over the database
+	db.AddNetwork(netip.MustParsePrefix("203.0.113.0/28"), Location{Region: "office"})
+	loc, _ = db.Lookup(netip.MustParseAddr("203.0.113.1"))
+	assert.Equal(t, "office", loc.Region)
+
+	_, err = LoadCSV(strings.NewReader("10.0.0.0/8,,a,,\n10.1.0.0/16,,b,,\n"))
+	assert.ErrorContains(t, err, "overlaps")
+}
+
+func TestNearest(t *testing.T) {
+	nodes := []Node{
+		{ID: "self", Region: "us-east", Point: newYork, HasPoint: true, Self: true},
+		{ID: "fra", Region: "eu-central", Point: frankfurt, HasPoint: true, RTT: 90 * time.Millisecond},
+		{ID: "tyo-1", Region: "ap-northeast", RTT: 160 * time.Millisecond},
+		{ID: "tyo-2", Region: "ap-northeast", RTT: 150 * time.Millisecond},
+	}
+
+	choice, ok := Nearest(Location{Point: Point{Lat: 48.85, Lon: 2.35}, HasPoint: true}, true, nodes)
+	require.True(t, ok)
+	assert.Equal(t, "fra", choice.Node.ID)
+	assert.Equal(t, ReasonDistance, choice.Reason)
+
+	// Without a point, the fastest node of the region
+	choice, _ = Nearest(Location{Region: "ap-northeast"}, true, nodes)
+	assert.Equal(t, "tyo-2", choice.Node.ID)
+	assert.Equal(t, ReasonRegion, choice.Reason)
+
+	// Clients that cannot be located are served by the fastest node
+	choice, _ = Nearest(Location{}, false, nodes)
+	assert.Equal(t, "self", choice.Node.ID)
+	assert.Equal(t, ReasonLatency, choice.Reason)
+
+	choice, _ = Nearest(Location{Region: "sa-east"}, true, nodes[1:])
+	assert.Equal(t, "fra", choice.Node.ID)
+
+	_, ok = Nearest(Location{}, false, nil)
+	assert.False(t, ok)
+}
+
+func testRouter(t *testing.T) *Router {
+	t.Helper()
+	db, err := LoadCSV(strings.NewReader(testDB))
+	require.NoError(t, err)
+	return NewRouter(RouterOptions{
+		DB:    db,
+		Paths: []string{"/api/v1/files/"},
+		Nodes: func() []Node {
+			return []Node{
+				{ID: "self", Point: newYork, HasPoint: true, URL: "https://us.example.com", Self: true},
+				{ID: "fra", Point: frankfurt, HasPoint: true, URL: "https://192.0.2.7:8443/base/", RTT: time.Millisecond},
+			}
+		},
+	})
+}
+
+func TestRouterRoute(t *testing.T) {
+	router := testRouter(t)
+
+	req := httptest.NewRequest("GET", "/api/v1/files/a%2Fb?version=2", nil)
+	req.RemoteAddr = "203.0.113.5:4000"
+	target, ok := router.Route(req)
+	require.True(t, ok)
+	assert.Equal(t, "https://192.0.2.7:8443/base/api/v1/files/a%2Fb?version=2", target.String())
+
+	// Clients nearest to this node, writes and other paths stay here
+	req.RemoteAddr = "203.0.113.200:4000"
+	_, ok = router.Route(req)
+	assert.False(t, ok)
+
+	req = httptest.NewRequest("PUT", "/api/v1/files/a", nil)
+	req.RemoteAddr = "203.0.113.5:4000"
+	_, ok = router.Route(req)
+	assert.False(t, ok)
+
+	req = httptest.NewRequest("GET", "/health", nil)
+	req.RemoteAddr = "203.0.113.5:4000"
+	_, ok = router.Route(req)
+	assert.False(t, ok)
+}
+
+func TestDNSServer(t *testing.T) {
+	server, err := NewDNSServer(DNSOptions{Name: "files.example.com", Router: testRouter(t)})
+	require.NoError(t, err)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = server.ServeUDP(ctx, conn) }()
+
+	query := func(name string, typ dnsmessage.Type, subnet []byte) dnsmessage.Message {
+		t.Helper()
+		msg := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: 7, RecursionDesired: true},
+			Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET}},
+		}
+		if subnet != nil {
+			var opt dnsmessage.ResourceHeader
+			require.NoError(t, opt.SetEDNS0(1232, dnsmessage.RCodeSuccess, false))
+			msg.Additionals = []dnsmessage.Resource{{Header: opt, Body: &dnsmessage.OPTResource{
+				Options: []dnsmessage.Option{{Code: optionClientSubnet, Data: subnet}},
+			}}}
+		}
+		packet, err := msg.Pack()
+		require.NoError(t, err)
+		client, err := net.Dial("udp", conn.LocalAddr().String())
+		require.NoError(t, err)
+		defer client.Close()
+		_, err = client.Write(packet)
+		require.NoError(t, err)
+		require.NoError(t, client.SetReadDeadline(time.Now().Add(2*time.Second)))
+		buf := make([]byte, 512)
+		n, err := client.Read(buf)
+		require.NoError(t, err)
+		var reply dnsmessage.Message
+		require.NoError(t, reply.Unpack(buf[:n]))
+		assert.Equal(t, uint16(7), reply.ID)
+		return reply
+	}
+
+	// A client in Europe, named by its resolver's client subnet
+	ecs := []byte{0, 1, 24, 0, 203, 0, 113}
+	reply := query("Files.Example.com.", dnsmessage.TypeA, ecs)
+	require.Len(t, reply.Answers, 1)
+	assert.Equal(t, [4]byte{192, 0, 2, 7}, reply.Answers[0].Body.(*dnsmessage.AResource).A)
+	assert.Equal(t, uint32(DefaultDNSTTL/time.Second), reply.Answers[0].Header.TTL)
+
+	reply = query("files.example.com.", dnsmessage.TypeTXT, ecs)
+	require.Len(t, reply.Answers, 1)
+	assert.Equal(t, []string{"node=fra reason=distance url=https://192.0.2.7:8443/base/"}, reply.Answers[0].Body.(*dnsmessage.TXTResource).TXT)
+
+	// The loopback resolver cannot be located, so the fastest node answers
+	reply = query("files.example.com.", dnsmessage.TypeTXT, nil)
+	require.Len(t, reply.Answers, 1)
+	assert.Contains(t, reply.Answers[0].Body.(*dnsmessage.TXTResource).TXT[0], "node=self reason=latency")
+
+	reply = query("other.example.com.", dnsmessage.TypeA, nil)
+	assert.Equal(t, dnsmessage.RCodeRefused, reply.RCode)
+}
@@ -0,0 +1,161 @@
+package geo
+
+import (
+	"cmp"
+	"math"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/clientip"
+)
+
+// Reasons a node was chosen for a client
+const (
+	// ReasonDistance is the node nearest to the client's location
+	ReasonDistance = "distance"
+	// ReasonRegion is the fastest node in the client's region
+	ReasonRegion = "region"
+	// ReasonLatency is the fastest node, when the client cannot be located
+	// or no node is
+	ReasonLatency = "latency"
+)
+
+// Node is a healthy node clients may be sent to
+type Node struct {
+	ID       string
+	Region   string
+	Point    Point
+	HasPoint bool
+	// URL is the public base URL of the node; clients are not redirected to
+	// nodes without one
+	URL string
+	// RTT is the round-trip time from this node, zero when not measured
+	RTT  time.Duration
+	Self bool
+}
+
+// Choice is the node chosen for a client
+type Choice struct {
+	Node   Node
+	Reason string
+	// Distance is the distance to the client in kilometres, for
+	// ReasonDistance
+	Distance float64
+}
+
+// Nearest chooses the node for a client at loc. The nearest node with a
+// point wins when the client's point is known; otherwise the fastest node
+// of the client's region, and otherwise the fastest node of all, this node
+// first and nodes never measured last. located is false when the client
+// could not be located.
+func Nearest(loc Location, located bool, nodes []Node) (Choice, bool) {
+	if len(nodes) == 0 {
+		return Choice{}, false
+	}
+	if located && loc.HasPoint {
+		best, bestDistance := -1, math.Inf(1)
+		for i, n := range nodes {
+			if !n.HasPoint {
+				continue
+			}
+			if d := Distance(loc.Point, n.Point); d < bestDistance {
+				best, bestDistance = i, d
+			}
+		}
+		if best >= 0 {
+			return Choice{Node: nodes[best], Reason: ReasonDistance, Distance: bestDistance}, true
+		}
+	}
+	if located && loc.Region != "" {
+		var regional []Node
+		for _, n := range nodes {
+			if strings.EqualFold(n.Region, loc.Region) {
+				regional = append(regional, n)
+			}
+		}
+		if len(regional) > 0 {
+			return Choice{Node: fastest(regional), Reason: ReasonRegion}, true
+		}
+	}
+	return Choice{Node: fastest(nodes), Reason: ReasonLatency}, true
+}
+
+// fastest returns the node with the lowest round-trip time
+func fastest(nodes []Node) Node {
+	rtt := func(n Node) time.Duration {
+		switch {
+		case n.Self:
+			return 0
+		case n.RTT <= 0:
+			return math.MaxInt64
+		}
+		return n.RTT
+	}
+	return slices.MinFunc(nodes, func(a, b Node) int { return cmp.Compare(rtt(a), rtt(b)) })
+}
+
+// RouterOptions configures a Router
+type RouterOptions struct {
+	// DB locates clients; nil routes every client by latency
+	DB *DB
+	// Nodes returns the healthy nodes of the cluster, this node included
+	Nodes func() []Node
+	// Paths limits redirects to requests under one of them; empty
+	// redirects every request
+	Paths []string
+}
+
+// Router sends clients to the nearest node
+type Router struct {
+	opts RouterOptions
+}
+
+// NewRouter creates a router
+func NewRouter(opts RouterOptions) *Router {
+	return &Router{opts: opts}
+}
+
+// Choose returns the node for the client at addr
+func (r *Router) Choose(addr netip.Addr) (Choice, bool) {
+	loc, located := r.opts.DB.Lookup(addr)
+	return Nearest(loc, located, r.opts.Nodes())
+}
+
+// Route returns where to redirect a request: the same path and query on
+// the nearest node. ok is false when the request is to be served here,
+// because it changes data, is outside the routed paths, or this node is
+// the nearest.
+func (r *Router) Route(req *http.Request) (*url.URL, bool) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return nil, false
+	}
+	if len(r.opts.Paths) > 0 && !slices.ContainsFunc(r.opts.Paths, func(p string) bool {
+		return strings.HasPrefix(req.URL.Path, p)
+	}) {
+		return nil, false
+	}
+	addr, err := netip.ParseAddr(clientip.FromRequest(req))
+	if err != nil {
+		return nil, false
+	}
+	choice, ok := r.Choose(addr)
+	if !ok || choice.Node.Self || choice.Node.URL == "" {
+		return nil, false
+	}
+	base, err := url.Parse(choice.Node.URL)
+	if err != nil {
+		return nil, false
+	}
+	target := *req.URL
+	target.Scheme, target.Host = base.Scheme, base.Host
+	target.Path = strings.TrimSuffix(base.Path, "/") + req.URL.Path
+	if req.URL.RawPath != "" {
+		// Keep escaped slashes in keys escaped
+		target.RawPath = strings.TrimSuffix(base.EscapedPath(), "/") + req.URL.RawPath
+	}
+	return &target, true
+}
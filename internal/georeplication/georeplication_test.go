@@ -0,0 +1,394 @@
+package georeplication
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skpow1234/Peervault/internal/metadata"
+)
+
+const testSecret = "test-replication-secret"
+
+type memLocal struct {
+	store    *metadata.Store
+	mu       sync.Mutex
+	contents map[string][]byte
+}
+
+func newMemLocal(opts metadata.StoreOpts) *memLocal {
+	return &memLocal{store: metadata.NewStore(opts), contents: make(map[string][]byte)}
+}
+
+func (m *memLocal) Store() *metadata.Store { return m.store }
+
+func (m *memLocal) Content(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.contents[key]
+	return data, ok
+}
+
+func (m *memLocal) Put(ctx context.Context, rec metadata.FileRecord, content []byte) (metadata.Entry, error) {
+	m.mu.Lock()
+	if content == nil {
+		delete(m.contents, rec.Key)
+	} else {
+		m.contents[rec.Key] = content
+	}
+	m.mu.Unlock()
+	return m.store.Put(rec)
+}
+
+func (m *memLocal) Delete(ctx context.Context, key string) (metadata.Entry, error) {
+	m.mu.Lock()
+	delete(m.contents, key)
+	m.mu.Unlock()
+	return m.store.Delete(key)
+}
+
+// write makes a local change the way the file service does
+func (m *memLocal) write(t *testing.T, key, content string) {
+	t.Helper()
+	_, err := m.Put(context.Background(), metadata.FileRecord{Key: key, Size: int64(len(content))}, []byte(content))
+	require.NoError(t, err)
+}
+
+func (m *memLocal) has(key, content string) bool {
+	data, ok := m.Content(key)
+	_, err := m.store.Get(key)
+	return ok && err == nil && string(data) == content
+}
+
+func (m *memLocal) missing(key string) bool {
+	_, err := m.store.Get(key)
+	return err != nil
+}
+
+type cluster struct {
+	node   *Node
+	local  *memLocal
+	server *httptest.Server
+}
+
+// newCluster starts a cluster whose receiving endpoint is served by an
+// httptest server; targets are added with replicateTo
+func newCluster(t *testing.T, id string, cfg Config, opts metadata.StoreOpts) *cluster {
+	t.Helper()
+	c := &cluster{local: newMemLocal(opts)}
+	c.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.node.ServeHTTP(w, r)
+	}))
+	t.Cleanup(c.server.Close)
+
+	cfg.ClusterID = id
+	cfg.Secret = testSecret
+	cfg.RetryInterval = 20 * time.Millisecond
+	node, err := NewNode(cfg, c.local, nil)
+	require.NoError(t, err)
+	c.node = node
+	return c
+}
+
+// replicateTo rebuilds the node with the given targets
+func (c *cluster) replicateTo(t *testing.T, targets ...*cluster) {
+	t.Helper()
+	cfg := c.node.cfg
+	cfg.Targets = nil
+	for _, target := range targets {
+		cfg.Targets = append(cfg.Targets, TargetConfig{Name: target.node.ClusterID(), URL: target.server.URL})
+	}
+	node, err := NewNode(cfg, c.local, nil)
+	require.NoError(t, err)
+	c.node = node
+	c.node.Start()
+	t.Cleanup(c.node.Stop)
+}
+
+func TestParseTargets(t *testing.T) {
+	targets, err := ParseTargets("eu=https://eu.example.com, us=http://10.0.0.1:8081")
+	require.NoError(t, err)
+	assert.Equal(t, []TargetConfig{
+		{Name: "eu", URL: "https://eu.example.com"},
+		{Name: "us", URL: "http://10.0.0.1:8081"},
+	}, targets)
+
+	_, err = ParseTargets("eu")
+	assert.Error(t, err)
+}
+
+func TestNewNode_Validation(t *testing.T) {
+	local := newMemLocal(metadata.StoreOpts{})
+	_, err := NewNode(Config{Secret: testSecret}, local, nil)
+	assert.Error(t, err, "cluster id")
+	_, err = NewNode(Config{ClusterID: "a"}, local, nil)
+	assert.Error(t, err, "secret")
+	_, err = NewNode(Config{ClusterID: "a", Secret: testSecret, Policy: "first-wins"}, local, nil)
+	assert.Error(t, err, "policy")
+	_, err = NewNode(Config{ClusterID: "a", Secret: testSecret, Targets: []TargetConfig{{Name: "../b", URL: "http://b"}}}, local, nil)
+	assert.Error(t, err, "target name")
+	_, err = NewNode(Config{ClusterID: "a", Secret: testSecret, Targets: []TargetConfig{{Name: "b", URL: "b"}}}, local, nil)
+	assert.Error(t, err, "target url")
+}
+
+func TestReplication_PutsAndDeletes(t *testing.T) {
+	a := newCluster(t, "a", Config{}, metadata.StoreOpts{})
+	b := newCluster(t, "b", Config{}, metadata.StoreOpts{})
+	a.replicateTo(t, b)
+
+	a.local.write(t, "docs/one", "first")
+	a.local.write(t, "docs/two", "second")
+	require.Eventually(t, func() bool {
+		return b.local.has("docs/one", "first") && b.local.has("docs/two", "second")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	a.local.write(t, "docs/one", "updated")
+	_, err := a.local.Delete(context.Background(), "docs/two")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return b.local.has("docs/one", "updated") && b.local.missing("docs/two")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		st := a.node.Status().Targets[0]
+		return st.AckedIndex == a.local.store.LastIndex()
+	}, 5*time.Second, 10*time.Millisecond)
+	st := a.node.Status().Targets[0]
+	assert.True(t, st.Connected)
+	assert.Equal(t, "b", st.RemoteCluster)
+	assert.Zero(t, st.LagEntries)
+	assert.Zero(t, st.LagSeconds)
+
+	sources := b.node.Status().Sources
+	require.Len(t, sources, 1)
+	assert.Equal(t, "a", sources[0].Cluster)
+	assert.Equal(t, a.local.store.LastIndex(), sources[0].Index)
+}
+
+func TestReplication_PrefixFilter(t *testing.T) {
+	a := newCluster(t, "a", Config{}, metadata.StoreOpts{})
+	b := newCluster(t, "b", Config{}, metadata.StoreOpts{})
+
+	node, err := NewNode(Config{
+		ClusterID:     "a",
+		Secret:        testSecret,
+		RetryInterval: 20 * time.Millisecond,
+		Targets:       []TargetConfig{{Name: "b", URL: b.server.URL, Prefix: "eu/"}},
+	}, a.local, nil)
+	require.NoError(t, err)
+	node.Start()
+	defer node.Stop()
+
+	a.local.write(t, "us/report", "us")
+	a.local.write(t, "eu/report", "eu")
+	require.Eventually(t, func() bool { return b.local.has("eu/report", "eu") }, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, b.local.missing("us/report"))
+}
+
+func TestReplication_BidirectionalDoesNotLoop(t *testing.T) {
+	a := newCluster(t, "a", Config{}, metadata.StoreOpts{})
+	b := newCluster(t, "b", Config{}, metadata.StoreOpts{})
+	a.replicateTo(t, b)
+	b.replicateTo(t, a)
+
+	a.local.write(t, "from-a", "a")
+	b.local.write(t, "from-b", "b")
+	require.Eventually(t, func() bool {
+		return a.local.has("from-b", "b") && b.local.has("from-a", "a")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Each cluster wrote one key and applied one; nothing came back around
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, uint64(2), a.local.store.LastIndex())
+	assert.Equal(t, uint64(2), b.local.store.LastIndex())
+}
+
+func putChange(key, content, origin string, at time.Time) Change {
+	return Change{
+		Op:      metadata.OpPut,
+		Key:     key,
+		Record:  &metadata.FileRecord{Key: key, Size: int64(len(content))},
+		Content: []byte(content),
+		Origin:  origin,
+		Time:    at,
+	}
+}
+
+func TestApply_LastWriterWins(t *testing.T) {
+	b := newCluster(t, "b", Config{}, metadata.StoreOpts{})
+	b.local.write(t, "k", "local")
+	ctx := context.Background()
+	localTime := time.Now()
+
+	older := putChange("k", "older", "a", localTime.Add(-time.Hour))
+	older.Index = 1
+	res, err := b.node.Apply(ctx, &Batch{Source: "a", Instance: "i1", Index: 1, Changes: []Change{older}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Conflicts)
+	assert.True(t, b.local.has("k", "local"))
+
+	newerChange := putChange("k", "newer", "a", localTime.Add(time.Hour))
+	newerChange.Index = 2
+	res, err = b.node.Apply(ctx, &Batch{Source: "a", Instance: "i1", Index: 2, Changes: []Change{newerChange}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Applied)
+	assert.True(t, b.local.has("k", "newer"))
+
+	// The replicated write carries its source time, so an older change
+	// from a third cluster still loses
+	third := putChange("k", "third", "c", localTime.Add(30*time.Minute))
+	third.Index = 1
+	res, err = b.node.Apply(ctx, &Batch{Source: "c", Instance: "i2", Index: 1, Changes: []Change{third}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Conflicts)
+	assert.True(t, b.local.has("k", "newer"))
+
+	// A local delete outranks older puts of the deleted key
+	entry, err := b.local.Delete(ctx, "k")
+	require.NoError(t, err)
+	b.node.noteEntry(entry)
+	stale := putChange("k", "stale", "c", entry.Timestamp.Add(-time.Minute))
+	stale.Index = 2
+	res, err = b.node.Apply(ctx, &Batch{Source: "c", Instance: "i2", Index: 2, Changes: []Change{stale}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Conflicts)
+	assert.True(t, b.local.missing("k"))
+}
+
+func TestApply_SourceWins(t *testing.T) {
+	b := newCluster(t, "b", Config{Policy: PolicySourceWins}, metadata.StoreOpts{})
+	b.local.write(t, "k", "local")
+
+	older := putChange("k", "older", "a", time.Now().Add(-time.Hour))
+	older.Index = 1
+	res, err := b.node.Apply(context.Background(), &Batch{Source: "a", Instance: "i1", Index: 1, Changes: []Change{older}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Applied)
+	assert.True(t, b.local.has("k", "older"))
+}
+
+func TestApply_SkipsReplayedAndOwnChanges(t *testing.T) {
+	b := newCluster(t, "b", Config{}, metadata.StoreOpts{})
+	ctx := context.Background()
+	change := putChange("k", "v1", "a", time.Now())
+	change.Index = 5
+	batch := &Batch{Source: "a", Instance: "i1", Index: 5, Changes: []Change{change}}
+
+	res, err := b.node.Apply(ctx, batch)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Applied)
+	res, err = b.node.Apply(ctx, batch)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Skipped)
+	assert.Equal(t, uint64(5), res.Index)
+
+	// A restarted sender numbers its log from 1 again
+	restarted := putChange("k", "v2", "a", time.Now())
+	restarted.Index = 1
+	res, err = b.node.Apply(ctx, &Batch{Source: "a", Instance: "i2", Index: 1, Changes: []Change{restarted}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Applied)
+	assert.True(t, b.local.has("k", "v2"))
+
+	echo := putChange("k", "echo", "b", time.Now().Add(time.Hour))
+	echo.Index = 2
+	res, err = b.node.Apply(ctx, &Batch{Source: "a", Instance: "i2", Index: 2, Changes: []Change{echo}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Skipped)
+	assert.True(t, b.local.has("k", "v2"))
+
+	_, err = b.node.Apply(ctx, &Batch{Source: "b"})
+	assert.Error(t, err)
+}
+
+func TestReplication_ResumesFromCheckpoint(t *testing.T) {
+	stateDir := t.TempDir()
+	a := newCluster(t, "a", Config{StateDir: stateDir}, metadata.StoreOpts{})
+	b := newCluster(t, "b", Config{StateDir: t.TempDir()}, metadata.StoreOpts{})
+	a.node.cfg.Targets = []TargetConfig{{Name: "b", URL: b.server.URL}}
+	node, err := NewNode(a.node.cfg, a.local, nil)
+	require.NoError(t, err)
+
+	node.Start()
+	a.local.write(t, "one", "1")
+	require.Eventually(t, func() bool { return b.local.has("one", "1") }, 5*time.Second, 10*time.Millisecond)
+	node.Stop()
+
+	// Changes made while stopped are shipped from the checkpoint on
+	a.local.write(t, "two", "2")
+	node.Start()
+	defer node.Stop()
+	require.Eventually(t, func() bool { return b.local.has("two", "2") }, 5*time.Second, 10*time.Millisecond)
+
+	src := b.node.Status().Sources[0]
+	assert.Equal(t, uint64(2), src.Applied)
+	assert.Zero(t, src.Skipped)
+
+	// The checkpoint and the receiver's progress survive restarts
+	reloaded, err := NewNode(a.node.cfg, a.local, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), reloaded.replicators[0].checkpoint.Index)
+	receiver, err := NewNode(b.node.cfg, b.local, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), receiver.Status().Sources[0].Index)
+}
+
+func TestReplication_ResyncPrunesDeletedKeys(t *testing.T) {
+	a := newCluster(t, "a", Config{}, metadata.StoreOpts{MaxLogEntries: 2})
+	b := newCluster(t, "b", Config{}, metadata.StoreOpts{})
+	a.node.cfg.Targets = []TargetConfig{{Name: "b", URL: b.server.URL}}
+	node, err := NewNode(a.node.cfg, a.local, nil)
+	require.NoError(t, err)
+
+	node.Start()
+	a.local.write(t, "keep", "k")
+	a.local.write(t, "gone", "g")
+	require.Eventually(t, func() bool { return b.local.has("gone", "g") }, 5*time.Second, 10*time.Millisecond)
+	node.Stop()
+
+	// Truncate the log past the checkpoint
+	_, err = a.local.Delete(context.Background(), "gone")
+	require.NoError(t, err)
+	for i := range 3 {
+		a.local.write(t, "new"+strconv.Itoa(i), "n")
+	}
+
+	node.Start()
+	defer node.Stop()
+	require.Eventually(t, func() bool {
+		return b.local.missing("gone") && b.local.has("new2", "n") && b.local.has("keep", "k")
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(1), node.Status().Targets[0].Resyncs)
+}
+
+func TestServeHTTP_RejectsBadSignatures(t *testing.T) {
+	b := newCluster(t, "b", Config{}, metadata.StoreOpts{})
+	body := []byte(`{"source":"a","instance":"i","index":1,"changes":[]}`)
+
+	post := func(timestamp, signature string) int {
+		req, err := http.NewRequest(http.MethodPost, b.server.URL+Path, bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set(HeaderTimestamp, timestamp)
+		req.Header.Set(HeaderSignature, signature)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	assert.Equal(t, http.StatusOK, post(now, sign([]byte(testSecret), now, body)))
+	assert.Equal(t, http.StatusUnauthorized, post(now, sign([]byte("wrong"), now, body)))
+	assert.Equal(t, http.StatusUnauthorized, post(now, ""))
+
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	assert.Equal(t, http.StatusUnauthorized, post(stale, sign([]byte(testSecret), stale, body)))
+}
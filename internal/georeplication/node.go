@@ -0,0 +1,628 @@
+package georeplication
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/metadata"
+)
+
+const (
+	// maxBatchBody bounds the size of an inbound batch request
+	maxBatchBody = 1 << 30
+	// tombstoneTTL is how long deletes are remembered for conflict checks
+	tombstoneTTL = 30 * 24 * time.Hour
+)
+
+var targetNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Local is the cluster's file store as seen by replication
+type Local interface {
+	// Store is the metadata store whose log is replicated
+	Store() *metadata.Store
+	// Content returns the content of a key, if it has any
+	Content(key string) ([]byte, bool)
+	// Put writes a replicated record and its content; nil content removes
+	// any existing content
+	Put(ctx context.Context, rec metadata.FileRecord, content []byte) (metadata.Entry, error)
+	// Delete removes a replicated key
+	Delete(ctx context.Context, key string) (metadata.Entry, error)
+}
+
+// TargetConfig is a remote cluster to replicate to
+type TargetConfig struct {
+	Name string `yaml:"name" json:"name"`
+	// URL is the base URL of the remote REST API, e.g. https://eu.example.com
+	URL string `yaml:"url" json:"url"`
+	// Secret signs batches for this target; empty uses the node secret
+	Secret string `yaml:"secret,omitempty" json:"-"`
+	// Prefix limits replication to keys starting with it
+	Prefix string `yaml:"prefix,omitempty" json:"prefix,omitempty"`
+}
+
+// Config configures a cluster's geo-replication
+type Config struct {
+	// ClusterID names this cluster; it must differ on every cluster
+	ClusterID string
+	// Secret authenticates batches from other clusters and signs outbound
+	// batches unless a target has its own
+	Secret string
+	Policy ConflictPolicy
+	// StateDir persists checkpoints and key origins; empty keeps them in
+	// memory, which resends everything after a restart
+	StateDir string
+	Targets  []TargetConfig
+	// BatchSize is the maximum number of changes per request
+	BatchSize int
+	// RetryInterval is the delay before reconnecting to a failed target
+	RetryInterval time.Duration
+}
+
+// ParseTargets parses a comma-separated list of name=url targets
+func ParseTargets(s string) ([]TargetConfig, error) {
+	var targets []TargetConfig
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, url, ok := strings.Cut(item, "=")
+		if !ok || name == "" || url == "" {
+			return nil, fmt.Errorf("georeplication: invalid target %q, want name=url", item)
+		}
+		targets = append(targets, TargetConfig{Name: name, URL: url})
+	}
+	return targets, nil
+}
+
+// origin records where the current local state of a key came from
+type origin struct {
+	Origin string    `json:"origin"`
+	Time   time.Time `json:"time"`
+	// From is the cluster that sent the change, which differs from Origin
+	// for changes relayed through another cluster
+	From string `json:"from,omitempty"`
+	// LocalIndex and LocalUpdated identify the local write that applied
+	// it; a later local write makes the origin stale
+	LocalIndex   uint64    `json:"local_index"`
+	LocalUpdated time.Time `json:"local_updated,omitempty"`
+	Deleted      bool      `json:"deleted,omitempty"`
+}
+
+// SourceStatus reports batches received from one remote cluster
+type SourceStatus struct {
+	Cluster   string    `json:"cluster"`
+	Instance  string    `json:"instance"`
+	Index     uint64    `json:"index"`
+	LastBatch time.Time `json:"last_batch,omitempty"`
+	Applied   uint64    `json:"applied"`
+	Skipped   uint64    `json:"skipped"`
+	Conflicts uint64    `json:"conflicts"`
+	Failed    uint64    `json:"failed"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Status reports a cluster's geo-replication in both directions
+type Status struct {
+	ClusterID string         `json:"cluster_id"`
+	Policy    ConflictPolicy `json:"policy"`
+	Targets   []TargetStatus `json:"targets"`
+	Sources   []SourceStatus `json:"sources"`
+}
+
+type nodeState struct {
+	Sources map[string]*SourceStatus `json:"sources"`
+	Origins map[string]origin        `json:"origins"`
+}
+
+// Node is one cluster's end of geo-replication: it applies batches from
+// other clusters and ships local changes to its targets
+type Node struct {
+	cfg    Config
+	local  Local
+	logger *slog.Logger
+	// instance identifies this process's metadata log
+	instance string
+	client   *http.Client
+	now      func() time.Time
+
+	// applyMu serialises batches so conflict checks and writes don't race
+	applyMu sync.Mutex
+
+	mu          sync.Mutex
+	state       nodeState
+	replicators []*Replicator
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// NewNode validates cfg and loads persisted state
+func NewNode(cfg Config, local Local, logger *slog.Logger) (*Node, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if cfg.ClusterID == "" {
+		return nil, errors.New("georeplication: cluster id is required")
+	}
+	if cfg.Secret == "" {
+		return nil, errors.New("georeplication: secret is required")
+	}
+	policy, err := ParsePolicy(string(cfg.Policy))
+	if err != nil {
+		return nil, err
+	}
+	cfg.Policy = policy
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = 5 * time.Second
+	}
+
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	n := &Node{
+		cfg:      cfg,
+		local:    local,
+		logger:   logger,
+		instance: hex.EncodeToString(id[:]),
+		client:   &http.Client{Timeout: 5 * time.Minute},
+		now:      time.Now,
+		state: nodeState{
+			Sources: make(map[string]*SourceStatus),
+			Origins: make(map[string]origin),
+		},
+	}
+	if err := n.load(); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, target := range cfg.Targets {
+		if !targetNamePattern.MatchString(target.Name) {
+			return nil, fmt.Errorf("georeplication: invalid target name %q", target.Name)
+		}
+		if seen[target.Name] {
+			return nil, fmt.Errorf("georeplication: duplicate target %q", target.Name)
+		}
+		seen[target.Name] = true
+		r, err := newReplicator(n, target)
+		if err != nil {
+			return nil, err
+		}
+		n.replicators = append(n.replicators, r)
+	}
+	return n, nil
+}
+
+// ClusterID returns the ID of this cluster
+func (n *Node) ClusterID() string {
+	return n.cfg.ClusterID
+}
+
+// Start ships local changes to every target until Stop is called
+func (n *Node) Start() {
+	n.mu.Lock()
+	if n.stop != nil {
+		n.mu.Unlock()
+		return
+	}
+	n.stop = make(chan struct{})
+	n.done = make(chan struct{})
+	stop, done := n.stop, n.done
+	n.mu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(1 + len(n.replicators))
+	go func() {
+		defer wg.Done()
+		n.trackDeletes(stop)
+	}()
+	for _, r := range n.replicators {
+		go func() {
+			defer wg.Done()
+			r.run(stop)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+}
+
+// Stop stops replication; batches in flight are abandoned and resent later
+func (n *Node) Stop() {
+	n.mu.Lock()
+	stop, done := n.stop, n.done
+	n.stop, n.done = nil, nil
+	n.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Status reports replication to every target and from every source
+func (n *Node) Status() Status {
+	status := Status{
+		ClusterID: n.cfg.ClusterID,
+		Policy:    n.cfg.Policy,
+		Targets:   make([]TargetStatus, 0, len(n.replicators)),
+		Sources:   []SourceStatus{},
+	}
+	for _, r := range n.replicators {
+		status.Targets = append(status.Targets, r.Status())
+	}
+	n.mu.Lock()
+	for _, src := range n.state.Sources {
+		status.Sources = append(status.Sources, *src)
+	}
+	n.mu.Unlock()
+	sort.Slice(status.Sources, func(i, j int) bool { return status.Sources[i].Cluster < status.Sources[j].Cluster })
+	return status
+}
+
+// ServeHTTP receives a signed batch and applies it
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBody))
+	if err != nil {
+		http.Error(w, "Failed to read batch", http.StatusRequestEntityTooLarge)
+		return
+	}
+	err = verify([]byte(n.cfg.Secret), r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderSignature), body, n.now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	// A standby can't take writes; the sender retries until it's promoted
+	if n.local.Store().Role() != metadata.RolePrimary {
+		http.Error(w, "Metadata store is not primary", http.StatusServiceUnavailable)
+		return
+	}
+
+	var batch Batch
+	if err := json.Unmarshal(body, &batch); err != nil {
+		http.Error(w, "Invalid batch", http.StatusBadRequest)
+		return
+	}
+	result, err := n.Apply(r.Context(), &batch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// Apply applies a batch from another cluster. Changes that fail locally
+// (e.g. a key under retention) are reported and skipped so one key can't
+// stall replication.
+func (n *Node) Apply(ctx context.Context, batch *Batch) (*BatchResult, error) {
+	if batch.Source == "" {
+		return nil, errors.New("georeplication: batch source is required")
+	}
+	if batch.Source == n.cfg.ClusterID {
+		return nil, fmt.Errorf("georeplication: batch from own cluster %q", batch.Source)
+	}
+
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	src, ok := n.state.Sources[batch.Source]
+	if !ok {
+		src = &SourceStatus{Cluster: batch.Source}
+		n.state.Sources[batch.Source] = src
+	}
+	// A new instance restarted its log from index 1
+	if src.Instance != batch.Instance {
+		src.Instance = batch.Instance
+		src.Index = 0
+	}
+	applied := src.Index
+	n.mu.Unlock()
+
+	result := &BatchResult{Cluster: n.cfg.ClusterID}
+	for _, change := range batch.Changes {
+		if !batch.Resync && change.Index <= applied {
+			// Already applied; the sender missed our acknowledgement
+			result.Skipped++
+			continue
+		}
+		if err := n.applyChange(ctx, batch.Source, change, result); err != nil {
+			result.Failed++
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", change.Key, err))
+		}
+	}
+	if batch.Resync && batch.Final && batch.Prune {
+		n.prune(ctx, batch.Source, batch.Keys, result)
+	}
+
+	n.mu.Lock()
+	if !batch.Resync && batch.Index > src.Index || batch.Resync && batch.Final {
+		src.Index = batch.Index
+	}
+	src.LastBatch = n.now()
+	src.Applied += uint64(result.Applied)
+	src.Skipped += uint64(result.Skipped)
+	src.Conflicts += uint64(result.Conflicts)
+	src.Failed += uint64(result.Failed)
+	if len(result.Errors) > 0 {
+		src.LastError = result.Errors[len(result.Errors)-1]
+	}
+	result.Index = src.Index
+	err := n.saveLocked()
+	n.mu.Unlock()
+	if err != nil {
+		n.logger.Error("Failed to persist replication state", "error", err)
+	}
+	return result, nil
+}
+
+// applyChange applies one change under the conflict policy
+func (n *Node) applyChange(ctx context.Context, from string, change Change, result *BatchResult) error {
+	if change.Origin == "" {
+		change.Origin = from
+	}
+	if change.Time.IsZero() {
+		return errors.New("change has no timestamp")
+	}
+	// Our own change coming back around a replication loop
+	if change.Origin == n.cfg.ClusterID {
+		result.Skipped++
+		return nil
+	}
+
+	current, err := n.local.Store().Get(change.Key)
+	exists := err == nil
+	n.mu.Lock()
+	prev, known := n.state.Origins[change.Key]
+	n.mu.Unlock()
+
+	// localTime and localOrigin describe the change that produced the
+	// current local state
+	localTime, localOrigin := current.UpdatedAt, n.cfg.ClusterID
+	switch {
+	case exists && known && !prev.Deleted && prev.LocalUpdated.Equal(current.UpdatedAt):
+		localTime, localOrigin = prev.Time, prev.Origin
+	case !exists && known && prev.Deleted:
+		localTime, localOrigin = prev.Time, prev.Origin
+	case !exists:
+		localTime = time.Time{}
+	}
+	if localOrigin == change.Origin && localTime.Equal(change.Time) {
+		result.Skipped++
+		return nil
+	}
+	if n.cfg.Policy == PolicyLastWriterWins && !localTime.IsZero() &&
+		!newer(change.Time, change.Origin, localTime, localOrigin) {
+		result.Conflicts++
+		result.Skipped++
+		return nil
+	}
+
+	next := origin{Origin: change.Origin, Time: change.Time, From: from}
+	switch change.Op {
+	case metadata.OpPut:
+		if change.Record == nil {
+			return errors.New("put without a record")
+		}
+		rec := *change.Record
+		rec.Key = change.Key
+		rec.Versions = nil
+		entry, err := n.local.Put(ctx, rec, change.Content)
+		if err != nil {
+			return err
+		}
+		next.LocalIndex = entry.Index
+		next.LocalUpdated = entry.Record.UpdatedAt
+	case metadata.OpDelete:
+		next.Deleted = true
+		if exists {
+			entry, err := n.local.Delete(ctx, change.Key)
+			if err != nil {
+				return err
+			}
+			next.LocalIndex = entry.Index
+		}
+	default:
+		return fmt.Errorf("unknown op %q", change.Op)
+	}
+
+	n.mu.Lock()
+	n.state.Origins[change.Key] = next
+	n.mu.Unlock()
+	result.Applied++
+	return nil
+}
+
+// prune deletes keys last replicated from source that it no longer has,
+// unless they were changed locally since
+func (n *Node) prune(ctx context.Context, source string, keys []string, result *BatchResult) {
+	keep := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		keep[key] = true
+	}
+
+	n.mu.Lock()
+	var stale []string
+	for key, o := range n.state.Origins {
+		if o.From == source && !o.Deleted && !keep[key] {
+			stale = append(stale, key)
+		}
+	}
+	n.mu.Unlock()
+	sort.Strings(stale)
+
+	for _, key := range stale {
+		n.mu.Lock()
+		o := n.state.Origins[key]
+		n.mu.Unlock()
+		current, err := n.local.Store().Get(key)
+		if err != nil || !current.UpdatedAt.Equal(o.LocalUpdated) {
+			continue
+		}
+		entry, err := n.local.Delete(ctx, key)
+		if err != nil {
+			result.Failed++
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", key, err))
+			continue
+		}
+		n.mu.Lock()
+		n.state.Origins[key] = origin{Origin: o.Origin, Time: n.now(), From: source, LocalIndex: entry.Index, Deleted: true}
+		n.mu.Unlock()
+		result.Applied++
+	}
+}
+
+// originOf returns the origin and time of a local log entry: the remote
+// cluster it was replicated from, or this cluster when it was made here
+func (n *Node) originOf(entry metadata.Entry) (string, time.Time) {
+	// Wait for a batch being applied to record the origins of its writes
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if o, ok := n.state.Origins[entry.Key]; ok && o.LocalIndex == entry.Index {
+		return o.Origin, o.Time
+	}
+	return n.cfg.ClusterID, entry.Timestamp
+}
+
+// originOfRecord is originOf for a record taken from a snapshot
+func (n *Node) originOfRecord(rec metadata.FileRecord) (string, time.Time) {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if o, ok := n.state.Origins[rec.Key]; ok && !o.Deleted && o.LocalUpdated.Equal(rec.UpdatedAt) {
+		return o.Origin, o.Time
+	}
+	return n.cfg.ClusterID, rec.UpdatedAt
+}
+
+// trackDeletes remembers local deletes so last-writer-wins can reject
+// older replicated puts of deleted keys
+func (n *Node) trackDeletes(stop <-chan struct{}) {
+	store := n.local.Store()
+	from := store.LastIndex()
+	for {
+		_, backlog, entries, cancel := store.Follow(from)
+		for _, entry := range backlog {
+			n.noteEntry(entry)
+			from = entry.Index
+		}
+		for open := true; open; {
+			select {
+			case <-stop:
+				cancel()
+				return
+			case entry, ok := <-entries:
+				if !ok {
+					open = false
+					break
+				}
+				n.noteEntry(entry)
+				from = entry.Index
+			}
+		}
+		cancel()
+		// Dropped as a slow subscriber; deletes missed meanwhile are lost
+		from = store.LastIndex()
+	}
+}
+
+func (n *Node) noteEntry(entry metadata.Entry) {
+	if entry.Op != metadata.OpDelete {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if o, ok := n.state.Origins[entry.Key]; ok && o.LocalIndex == entry.Index {
+		return
+	}
+	n.state.Origins[entry.Key] = origin{
+		Origin:     n.cfg.ClusterID,
+		Time:       entry.Timestamp,
+		LocalIndex: entry.Index,
+		Deleted:    true,
+	}
+}
+
+func (n *Node) statePath(name string) string {
+	if n.cfg.StateDir == "" {
+		return ""
+	}
+	return filepath.Join(n.cfg.StateDir, name)
+}
+
+func (n *Node) load() error {
+	path := n.statePath("state.json")
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state nodeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("georeplication: corrupt state %s: %w", path, err)
+	}
+	for id, src := range state.Sources {
+		n.state.Sources[id] = src
+	}
+	for key, o := range state.Origins {
+		n.state.Origins[key] = o
+	}
+	return nil
+}
+
+func (n *Node) saveLocked() error {
+	// Old tombstones are no longer needed to order concurrent changes
+	cutoff := n.now().Add(-tombstoneTTL)
+	for key, o := range n.state.Origins {
+		if o.Deleted && o.Time.Before(cutoff) {
+			delete(n.state.Origins, key)
+		}
+	}
+	return writeJSON(n.statePath("state.json"), n.state)
+}
+
+// writeJSON atomically replaces path with v; an empty path is a no-op
+func writeJSON(path string, v interface{}) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,403 @@
+package georeplication
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/metadata"
+)
+
+// TargetStatus reports replication to one remote cluster
+type TargetStatus struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Prefix string `json:"prefix,omitempty"`
+	// Connected is true while the last exchange with the target succeeded
+	Connected bool `json:"connected"`
+	// RemoteCluster is the ID the target reported
+	RemoteCluster string `json:"remote_cluster,omitempty"`
+	// SourceIndex is the local log index and AckedIndex the last one the
+	// target acknowledged
+	SourceIndex uint64 `json:"source_index"`
+	AckedIndex  uint64 `json:"acked_index"`
+	LagEntries  uint64 `json:"lag_entries"`
+	// LagSeconds is the age of the oldest change not yet acknowledged
+	LagSeconds float64   `json:"lag_seconds"`
+	Sent       uint64    `json:"sent"`
+	Resyncs    uint64    `json:"resyncs"`
+	LastSync   time.Time `json:"last_sync,omitempty"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+// checkpoint is the last log index a target acknowledged
+type checkpoint struct {
+	Instance  string    `json:"instance"`
+	Index     uint64    `json:"index"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Replicator ships the local metadata log to one remote cluster
+type Replicator struct {
+	node     *Node
+	target   TargetConfig
+	endpoint string
+	secret   []byte
+
+	mu         sync.Mutex
+	status     TargetStatus
+	checkpoint checkpoint
+	// pendingSince is the time of the oldest unacknowledged change
+	pendingSince time.Time
+}
+
+func newReplicator(n *Node, target TargetConfig) (*Replicator, error) {
+	u, err := url.Parse(target.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("georeplication: invalid url %q for target %q", target.URL, target.Name)
+	}
+	secret := target.Secret
+	if secret == "" {
+		secret = n.cfg.Secret
+	}
+	r := &Replicator{
+		node:     n,
+		target:   target,
+		endpoint: strings.TrimSuffix(target.URL, "/") + Path,
+		secret:   []byte(secret),
+		status:   TargetStatus{Name: target.Name, URL: target.URL, Prefix: target.Prefix},
+	}
+	if err := r.loadCheckpoint(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Status reports the target's connection and lag
+func (r *Replicator) Status() TargetStatus {
+	source := r.node.local.Store().LastIndex()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := r.status
+	st.SourceIndex = source
+	// A checkpoint from an earlier process says nothing about this log
+	if r.checkpoint.Instance == r.node.instance {
+		st.AckedIndex = r.checkpoint.Index
+	}
+	if source > st.AckedIndex {
+		st.LagEntries = source - st.AckedIndex
+		if !r.pendingSince.IsZero() {
+			st.LagSeconds = r.node.now().Sub(r.pendingSince).Seconds()
+		}
+	}
+	return st
+}
+
+// run replicates until stop is closed, reconnecting after failures
+func (r *Replicator) run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		err := r.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			// Dropped as a slow subscriber; pick up from the checkpoint
+			continue
+		}
+		r.mu.Lock()
+		r.status.Connected = false
+		r.status.LastError = err.Error()
+		r.mu.Unlock()
+		r.node.logger.Warn("Geo-replication to target failed", "target", r.target.Name, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.node.cfg.RetryInterval):
+		}
+	}
+}
+
+// stream follows the local log from the checkpoint and ships it in
+// batches. It returns nil when the subscription is dropped.
+func (r *Replicator) stream(ctx context.Context) error {
+	r.mu.Lock()
+	cp := r.checkpoint
+	r.mu.Unlock()
+	resume := cp.Instance == r.node.instance
+	var from uint64
+	if resume {
+		from = cp.Index
+	}
+
+	snap, pending, entries, cancel := r.node.local.Store().Follow(from)
+	defer cancel()
+	if snap != nil {
+		if err := r.resync(ctx, snap, resume); err != nil {
+			return err
+		}
+	}
+
+	batchSize := r.node.cfg.BatchSize
+	for {
+		if len(pending) == 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case entry, ok := <-entries:
+				if !ok {
+					return nil
+				}
+				pending = append(pending, entry)
+			}
+		}
+	drain:
+		for len(pending) < batchSize {
+			select {
+			case entry, ok := <-entries:
+				if !ok {
+					break drain
+				}
+				pending = append(pending, entry)
+			default:
+				break drain
+			}
+		}
+
+		r.mu.Lock()
+		if r.pendingSince.IsZero() {
+			r.pendingSince = pending[0].Timestamp
+		}
+		r.mu.Unlock()
+
+		n := min(len(pending), batchSize)
+		if err := r.ship(ctx, pending[:n]); err != nil {
+			return err
+		}
+		pending = pending[n:]
+
+		r.mu.Lock()
+		r.pendingSince = time.Time{}
+		if len(pending) > 0 {
+			r.pendingSince = pending[0].Timestamp
+		}
+		r.mu.Unlock()
+	}
+}
+
+// ship sends the changes of a run of log entries and advances the
+// checkpoint once the target acknowledges them
+func (r *Replicator) ship(ctx context.Context, entries []metadata.Entry) error {
+	var changes []Change
+	for _, entry := range entries {
+		if change, ok := r.change(entry); ok {
+			changes = append(changes, change)
+		}
+	}
+	last := entries[len(entries)-1].Index
+	if len(changes) > 0 {
+		batch := &Batch{
+			Source:   r.node.cfg.ClusterID,
+			Instance: r.node.instance,
+			Index:    last,
+			Changes:  changes,
+		}
+		if _, err := r.send(ctx, batch); err != nil {
+			return err
+		}
+	}
+	return r.ack(last, len(changes))
+}
+
+// change converts a log entry to a change for the target; entries outside
+// the prefix, superseded puts and the target's own changes are dropped
+func (r *Replicator) change(entry metadata.Entry) (Change, bool) {
+	if !strings.HasPrefix(entry.Key, r.target.Prefix) {
+		return Change{}, false
+	}
+	origin, at := r.node.originOf(entry)
+	if origin == r.remoteCluster() {
+		return Change{}, false
+	}
+
+	change := Change{Index: entry.Index, Op: entry.Op, Key: entry.Key, Origin: origin, Time: at}
+	if entry.Op == metadata.OpPut {
+		// Only the latest state is worth sending; a later entry in the log
+		// carries whatever replaced this one
+		current, err := r.node.local.Store().Get(entry.Key)
+		if err != nil || entry.Record == nil || !current.UpdatedAt.Equal(entry.Record.UpdatedAt) {
+			return Change{}, false
+		}
+		change.Record = recordForChange(current)
+		if data, ok := r.node.local.Content(entry.Key); ok {
+			change.Content = data
+		}
+	}
+	return change, true
+}
+
+func (r *Replicator) remoteCluster() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.RemoteCluster
+}
+
+// resync sends every record of a snapshot when the log no longer reaches
+// back to the checkpoint. Keys the target got from us earlier and that are
+// gone now are pruned only when resuming our own log: after a restart the
+// in-memory store may simply not have been repopulated.
+func (r *Replicator) resync(ctx context.Context, snap *metadata.Snapshot, prune bool) error {
+	r.mu.Lock()
+	r.status.Resyncs++
+	r.mu.Unlock()
+	r.node.logger.Info("Resynchronising geo-replication target", "target", r.target.Name, "index", snap.Index)
+
+	keys := make([]string, 0, len(snap.Records))
+	for key := range snap.Records {
+		if strings.HasPrefix(key, r.target.Prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	batchSize := r.node.cfg.BatchSize
+	sent := 0
+	for start := 0; ; start += batchSize {
+		end := min(start+batchSize, len(keys))
+		batch := &Batch{
+			Source:   r.node.cfg.ClusterID,
+			Instance: r.node.instance,
+			Index:    snap.Index,
+			Resync:   true,
+			Final:    end == len(keys),
+			Changes:  []Change{},
+		}
+		for _, key := range keys[start:end] {
+			rec := snap.Records[key]
+			origin, at := r.node.originOfRecord(rec)
+			if origin == r.remoteCluster() {
+				continue
+			}
+			change := Change{Index: snap.Index, Op: metadata.OpPut, Key: key, Record: recordForChange(rec), Origin: origin, Time: at}
+			if data, ok := r.node.local.Content(key); ok {
+				change.Content = data
+			}
+			batch.Changes = append(batch.Changes, change)
+		}
+		if batch.Final {
+			batch.Prune = prune
+			batch.Keys = keys
+		}
+		if _, err := r.send(ctx, batch); err != nil {
+			return err
+		}
+		sent += len(batch.Changes)
+		if batch.Final {
+			break
+		}
+	}
+	return r.ack(snap.Index, sent)
+}
+
+// send signs and posts a batch
+func (r *Replicator) send(ctx context.Context, batch *Batch) (*BatchResult, error) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(r.node.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, sign(r.secret, timestamp, body))
+
+	resp, err := r.node.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("georeplication: %s: %s: %s", r.endpoint, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var result BatchResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("georeplication: decoding response from %s: %w", r.endpoint, err)
+	}
+	if result.Cluster == r.node.cfg.ClusterID {
+		return nil, errors.New("georeplication: target is this cluster")
+	}
+	if result.Failed > 0 {
+		r.node.logger.Warn("Target rejected replicated changes", "target", r.target.Name, "failed", result.Failed, "errors", result.Errors)
+	}
+
+	r.mu.Lock()
+	r.status.Connected = true
+	r.status.RemoteCluster = result.Cluster
+	r.status.LastSync = r.node.now()
+	r.status.LastError = ""
+	r.mu.Unlock()
+	return &result, nil
+}
+
+// ack advances and persists the checkpoint
+func (r *Replicator) ack(index uint64, sent int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkpoint = checkpoint{Instance: r.node.instance, Index: index, UpdatedAt: r.node.now()}
+	r.status.Sent += uint64(sent)
+	return writeJSON(r.checkpointPath(), r.checkpoint)
+}
+
+func (r *Replicator) checkpointPath() string {
+	return r.node.statePath("checkpoint-" + r.target.Name + ".json")
+}
+
+func (r *Replicator) loadCheckpoint() error {
+	path := r.checkpointPath()
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &r.checkpoint); err != nil {
+		return fmt.Errorf("georeplication: corrupt checkpoint %s: %w", path, err)
+	}
+	return nil
+}
+
+// recordForChange strips what stays local from a record before sending it
+func recordForChange(rec metadata.FileRecord) *metadata.FileRecord {
+	rec.Versions = nil
+	return &rec
+}
@@ -0,0 +1,100 @@
+package gitremote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Git runs git commands in the local repository
+type Git struct {
+	// Dir is the repository's git directory; empty uses GIT_DIR, which
+	// git sets for remote helpers
+	Dir string
+}
+
+// command returns git with args, its output going to stdout
+func (g *Git) command(ctx context.Context, stdin io.Reader, stdout io.Writer, args ...string) *exec.Cmd {
+	if g.Dir != "" {
+		args = append([]string{"--git-dir", g.Dir}, args...)
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	return cmd
+}
+
+// run runs git with args, returning its error output in the error
+func (g *Git) run(ctx context.Context, stdin io.Reader, stdout io.Writer, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := g.command(ctx, stdin, stdout, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("git %s: %w: %s", args[0], err, msg)
+		}
+		return fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return nil
+}
+
+// output runs git with args and returns its trimmed output
+func (g *Git) output(ctx context.Context, args ...string) (string, error) {
+	var stdout bytes.Buffer
+	err := g.run(ctx, nil, &stdout, args...)
+	return strings.TrimSpace(stdout.String()), err
+}
+
+// check runs git with args and reports whether it succeeded, for commands
+// that answer a question with their exit status
+func (g *Git) check(ctx context.Context, args ...string) (bool, error) {
+	err := g.command(ctx, nil, nil, args...).Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GitDir returns the path of the git directory
+func (g *Git) GitDir(ctx context.Context) (string, error) {
+	return g.output(ctx, "rev-parse", "--absolute-git-dir")
+}
+
+// Resolve returns the object ID a local ref or revision names
+func (g *Git) Resolve(ctx context.Context, rev string) (string, error) {
+	return g.output(ctx, "rev-parse", "--verify", "--end-of-options", rev)
+}
+
+// HasObject reports whether the object id is in the local repository
+func (g *Git) HasObject(ctx context.Context, id string) bool {
+	return g.command(ctx, nil, nil, "cat-file", "-e", id).Run() == nil
+}
+
+// IsAncestor reports whether the commit ancestor is reachable from commit
+func (g *Git) IsAncestor(ctx context.Context, ancestor, commit string) (bool, error) {
+	return g.check(ctx, "merge-base", "--is-ancestor", ancestor, commit)
+}
+
+// PackObjects writes to w a packfile of the objects reachable from the
+// include object IDs but not from the exclude ones
+func (g *Git) PackObjects(ctx context.Context, include, exclude []string, w io.Writer) error {
+	var revs strings.Builder
+	for _, id := range include {
+		revs.WriteString(id + "\n")
+	}
+	for _, id := range exclude {
+		revs.WriteString("^" + id + "\n")
+	}
+	return g.run(ctx, strings.NewReader(revs.String()), w, "pack-objects", "--revs", "--stdout", "-q")
+}
+
+// IndexPack adds the objects of the packfile read from r to the local
+// repository
+func (g *Git) IndexPack(ctx context.Context, r io.Reader) error {
+	return g.run(ctx, r, io.Discard, "index-pack", "--stdin")
+}
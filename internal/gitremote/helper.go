@@ -0,0 +1,397 @@
+package gitremote
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Helper answers git over the remote helper protocol: git writes commands
+// on stdin, one per line, and reads the replies on stdout. See
+// gitremote-helpers(7).
+type Helper struct {
+	remote *Remote
+	git    *Git
+	// url identifies the remote in the record of fetched packs
+	url string
+	// state is the state listed to git, which fetches from it
+	state *State
+}
+
+// NewHelper returns a helper for remote, known to git as url
+func NewHelper(remote *Remote, git *Git, url string) *Helper {
+	return &Helper{remote: remote, git: git, url: url}
+}
+
+// Run answers the commands read from in until git is done
+func (h *Helper) Run(ctx context.Context, in io.Reader, out io.Writer) error {
+	r := bufio.NewReader(in)
+	w := bufio.NewWriter(out)
+	for {
+		line, err := readLine(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			return nil
+		}
+
+		switch {
+		case line == "capabilities":
+			fmt.Fprint(w, "fetch\npush\n\n")
+		case line == "list" || line == "list for-push":
+			if err := h.list(ctx, w); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "fetch "):
+			batch, err := readBatch(r, line)
+			if err != nil {
+				return err
+			}
+			var ids []string
+			for _, cmd := range batch {
+				id, _, _ := strings.Cut(strings.TrimPrefix(cmd, "fetch "), " ")
+				ids = append(ids, id)
+			}
+			if err := h.fetch(ctx, ids); err != nil {
+				return err
+			}
+			fmt.Fprint(w, "\n")
+		case strings.HasPrefix(line, "push "):
+			batch, err := readBatch(r, line)
+			if err != nil {
+				return err
+			}
+			for i, cmd := range batch {
+				batch[i] = strings.TrimPrefix(cmd, "push ")
+			}
+			results, err := h.push(ctx, batch)
+			if err != nil {
+				return err
+			}
+			for _, result := range results {
+				fmt.Fprintln(w, result)
+			}
+			fmt.Fprint(w, "\n")
+		default:
+			return fmt.Errorf("unsupported command %q", line)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// readLine reads a command without its line end
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && (line == "" || !errors.Is(err, io.EOF)) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readBatch reads the commands following first up to the blank line
+// ending a batch of fetch or push commands
+func readBatch(r *bufio.Reader, first string) ([]string, error) {
+	batch := []string{first}
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			return batch, nil
+		}
+		batch = append(batch, line)
+	}
+}
+
+// list writes the refs of the remote and the ref HEAD points to
+func (h *Helper) list(ctx context.Context, w io.Writer) error {
+	state, _, err := h.remote.Load(ctx)
+	if err != nil {
+		return err
+	}
+	h.state = state
+	names := make([]string, 0, len(state.Refs))
+	for name := range state.Refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s %s\n", state.Refs[name], name)
+	}
+	if _, ok := state.Refs[state.Head]; ok {
+		fmt.Fprintf(w, "@%s HEAD\n", state.Head)
+	}
+	fmt.Fprint(w, "\n")
+	return nil
+}
+
+// fetch downloads packs until the objects ids are in the local repository.
+// Packs fetched before are skipped unless the objects are still missing
+// without them.
+func (h *Helper) fetch(ctx context.Context, ids []string) error {
+	state := h.state
+	if state == nil {
+		var err error
+		if state, _, err = h.remote.Load(ctx); err != nil {
+			return err
+		}
+	}
+	fetched, err := h.fetchedPacks(ctx)
+	if err != nil {
+		return err
+	}
+	missing := func() bool {
+		return slices.ContainsFunc(ids, func(id string) bool { return !h.git.HasObject(ctx, id) })
+	}
+
+	indexed := make(map[string]bool)
+	for _, skipFetched := range []bool{true, false} {
+		if !missing() {
+			return nil
+		}
+		for _, name := range state.Packs {
+			if indexed[name] || (skipFetched && fetched[name]) {
+				continue
+			}
+			if err := h.indexPack(ctx, name); err != nil {
+				return err
+			}
+			indexed[name] = true
+			if err := h.recordPacks(ctx, name); err != nil {
+				return err
+			}
+		}
+	}
+	if missing() {
+		return errors.New("the remote's packs lack objects its refs point to")
+	}
+	return nil
+}
+
+// indexPack downloads the pack name into the local repository
+func (h *Helper) indexPack(ctx context.Context, name string) error {
+	pr, pw := io.Pipe()
+	downloaded := make(chan error, 1)
+	go func() {
+		err := h.remote.DownloadPack(ctx, name, pw)
+		pw.CloseWithError(err)
+		downloaded <- err
+	}()
+	err := h.git.IndexPack(ctx, pr)
+	pr.Close()
+	// A download cut short by index-pack failing is not the cause
+	if downloadErr := <-downloaded; downloadErr != nil && !errors.Is(downloadErr, io.ErrClosedPipe) {
+		return downloadErr
+	}
+	return err
+}
+
+// recordPath returns the file listing the packs fetched from the remote
+func (h *Helper) recordPath(ctx context.Context) (string, error) {
+	gitDir, err := h.git.GitDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(h.url))
+	return filepath.Join(gitDir, "peervault", hex.EncodeToString(sum[:8])+".packs"), nil
+}
+
+// fetchedPacks returns the names of the packs fetched from the remote
+func (h *Helper) fetchedPacks(ctx context.Context) (map[string]bool, error) {
+	path, err := h.recordPath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	fetched := make(map[string]bool)
+	for _, name := range strings.Fields(string(data)) {
+		fetched[name] = true
+	}
+	return fetched, nil
+}
+
+// recordPacks adds the named packs to those fetched from the remote
+func (h *Helper) recordPacks(ctx context.Context, names ...string) error {
+	path, err := h.recordPath(ctx)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Fprintln(f, name)
+	}
+	return f.Close()
+}
+
+// refUpdate is a ref git pushes
+type refUpdate struct {
+	// src is the local ref, empty to delete dst
+	src, dst string
+	force    bool
+	// id is the object src points to
+	id string
+	// rejected is why the update was rejected
+	rejected string
+}
+
+// push uploads a pack for the refspecs and updates the refs of the
+// remote, returning a result line for each refspec
+func (h *Helper) push(ctx context.Context, refspecs []string) ([]string, error) {
+	state, _, err := h.remote.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := make([]*refUpdate, 0, len(refspecs))
+	var include []string
+	for _, spec := range refspecs {
+		u := &refUpdate{force: strings.HasPrefix(spec, "+")}
+		u.src, u.dst, _ = strings.Cut(strings.TrimPrefix(spec, "+"), ":")
+		updates = append(updates, u)
+		if u.src == "" {
+			continue
+		}
+		if u.id, err = h.git.Resolve(ctx, u.src); err != nil {
+			u.rejected = "src refspec does not match any object"
+			continue
+		}
+		// Check against the refs as listed to send no pack for updates
+		// that will be rejected anyway; they are checked again below
+		if u.rejected = h.reject(ctx, u, state.Refs[u.dst]); u.rejected == "" {
+			include = append(include, u.id)
+		}
+	}
+
+	var pack string
+	if len(include) > 0 {
+		// The remote has every object reachable from its refs
+		var exclude []string
+		for _, id := range state.Refs {
+			if h.git.HasObject(ctx, id) && !slices.Contains(exclude, id) {
+				exclude = append(exclude, id)
+			}
+		}
+		pr, pw := io.Pipe()
+		go func() { pw.CloseWithError(h.git.PackObjects(ctx, include, exclude, pw)) }()
+		pack, err = h.remote.UploadPack(ctx, pr)
+		pr.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	reasons := make([]string, len(updates))
+	_, err = h.remote.Update(ctx, func(state *State) error {
+		changed := false
+		for i, u := range updates {
+			reasons[i] = u.rejected
+			if u.rejected != "" {
+				continue
+			}
+			if reasons[i] = h.reject(ctx, u, state.Refs[u.dst]); reasons[i] != "" {
+				continue
+			}
+			if u.src == "" {
+				delete(state.Refs, u.dst)
+			} else {
+				state.Refs[u.dst] = u.id
+			}
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		if pack != "" && !slices.Contains(state.Packs, pack) {
+			state.Packs = append(state.Packs, pack)
+		}
+		state.pickHead()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if pack != "" {
+		// The objects of the pack came from here and need no fetching
+		if err := h.recordPacks(ctx, pack); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]string, len(updates))
+	for i, u := range updates {
+		if reasons[i] != "" {
+			results[i] = fmt.Sprintf("error %s %s", u.dst, reasons[i])
+		} else {
+			results[i] = "ok " + u.dst
+		}
+	}
+	return results, nil
+}
+
+// reject returns why u cannot replace the remote ref pointing to old, ""
+// when it can
+func (h *Helper) reject(ctx context.Context, u *refUpdate, old string) string {
+	switch {
+	case u.src == "" && old == "":
+		return "remote ref does not exist"
+	case u.src == "" || old == "" || old == u.id || u.force:
+		return ""
+	case strings.HasPrefix(u.dst, "refs/tags/"):
+		return "already exists"
+	case !h.git.HasObject(ctx, old):
+		return "fetch first"
+	}
+	ok, err := h.git.IsAncestor(ctx, old, u.id)
+	switch {
+	case err != nil:
+		return strings.ReplaceAll(err.Error(), "\n", " ")
+	case !ok:
+		return "non-fast-forward"
+	}
+	return ""
+}
+
+// pickHead points HEAD to main, master or the first branch when the ref
+// it points to is gone
+func (s *State) pickHead() {
+	if _, ok := s.Refs[s.Head]; ok {
+		return
+	}
+	s.Head = ""
+	for _, name := range []string{"refs/heads/main", "refs/heads/master"} {
+		if _, ok := s.Refs[name]; ok {
+			s.Head = name
+			return
+		}
+	}
+	for name := range s.Refs {
+		if strings.HasPrefix(name, "refs/heads/") && (s.Head == "" || name < s.Head) {
+			s.Head = name
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package gitremote
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore keeps files and JSON documents in memory
+type memStore struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	// conflicts fails as many writes of JSON documents as if another
+	// client changed them first
+	conflicts int
+}
+
+func newMemStore() *memStore {
+	return &memStore{files: make(map[string][]byte)}
+}
+
+func etag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *memStore) GetJSON(ctx context.Context, key string) (*client.JSONDocument, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[key]
+	if !ok {
+		return nil, &client.APIError{StatusCode: http.StatusNotFound, Message: "not found"}
+	}
+	return &client.JSONDocument{Data: data, ETag: etag(data)}, nil
+}
+
+func (s *memStore) PutJSON(ctx context.Context, key string, data []byte, w client.JSONWrite) (*client.JSONDocument, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, exists := s.files[key]
+	if s.conflicts > 0 || (w.Create && exists) || (w.IfMatch != "" && (!exists || etag(current) != w.IfMatch)) {
+		s.conflicts = max(s.conflicts-1, 0)
+		return nil, &client.APIError{StatusCode: http.StatusPreconditionFailed, Message: "precondition failed"}
+	}
+	s.files[key] = data
+	return &client.JSONDocument{Data: data, ETag: etag(data)}, nil
+}
+
+func (s *memStore) UploadDataAt(ctx context.Context, path, name, contentType string, r io.Reader, tags []string, metadata map[string]string) (*client.FileInfo, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[path] = data
+	return &client.FileInfo{Key: path, Size: int64(len(data))}, nil
+}
+
+func (s *memStore) DownloadContent(ctx context.Context, key string, w io.Writer) (int64, error) {
+	s.mu.Lock()
+	data, ok := s.files[key]
+	s.mu.Unlock()
+	if !ok {
+		return 0, &client.APIError{StatusCode: http.StatusNotFound, Message: "not found"}
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// packs returns the keys of the stored packfiles
+func (s *memStore) packs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.files {
+		if strings.HasSuffix(key, ".pack") {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// repo is a local git repository
+type repo struct {
+	t   *testing.T
+	dir string
+	git *Git
+}
+
+func newRepo(t *testing.T) *repo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	r := &repo{t: t, dir: dir, git: &Git{Dir: filepath.Join(dir, ".git")}}
+	r.run("init", "-q", "-b", "main")
+	return r
+}
+
+// run runs git in the work tree of the repository
+func (r *repo) run(args ...string) string {
+	r.t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", r.dir, "-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	out, err := cmd.CombinedOutput()
+	require.NoError(r.t, err, "git %v: %s", args, out)
+	return strings.TrimSpace(string(out))
+}
+
+// commit commits a change and returns the commit ID
+func (r *repo) commit(message string) string {
+	r.t.Helper()
+	r.run("commit", "-q", "--allow-empty", "-m", message)
+	return r.run("rev-parse", "HEAD")
+}
+
+// helper runs the commands on a helper of the repository for remote
+func (r *repo) helper(remote *Remote, commands ...string) string {
+	r.t.Helper()
+	var out bytes.Buffer
+	in := strings.Join(commands, "\n") + "\n\n"
+	require.NoError(r.t, NewHelper(remote, r.git, "peervault://test/repos/app").Run(context.Background(), strings.NewReader(in), &out))
+	return out.String()
+}
+
+func newRemote(t *testing.T, store Store) *Remote {
+	t.Helper()
+	remote, err := NewRemote(store, "repos/app")
+	require.NoError(t, err)
+	return remote
+}
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		url, server, repo string
+		err               bool
+	}{
+		{url: "peervault://localhost:8080/repos/app", server: "http://localhost:8080", repo: "repos/app"},
+		{url: "peervault:///repos/app/", repo: "repos/app"},
+		{url: "https://vault.example.com/app", server: "https://vault.example.com", repo: "app"},
+		{url: "peervault://localhost:8080/", err: true},
+		{url: "ssh://host/app", err: true},
+		{url: "https:///app", err: true},
+	}
+	for _, tt := range tests {
+		server, repo, err := ParseURL(tt.url)
+		if tt.err {
+			assert.Error(t, err, tt.url)
+			continue
+		}
+		require.NoError(t, err, tt.url)
+		assert.Equal(t, tt.server, server, tt.url)
+		assert.Equal(t, tt.repo, repo, tt.url)
+	}
+}
+
+func TestPushAndFetch(t *testing.T) {
+	store := newMemStore()
+	remote := newRemote(t, store)
+	a := newRepo(t)
+	first := a.commit("first")
+
+	assert.Equal(t, "fetch\npush\n\n", a.helper(remote, "capabilities"))
+	assert.Equal(t, "\n", a.helper(remote, "list for-push"))
+	assert.Equal(t, "ok refs/heads/main\n\n", a.helper(remote, "push refs/heads/main:refs/heads/main"))
+	assert.Equal(t, fmt.Sprintf("%s refs/heads/main\n@refs/heads/main HEAD\n\n", first), a.helper(remote, "list"))
+	require.Len(t, store.packs(), 1)
+
+	// Pushing what the remote has stores no pack
+	assert.Equal(t, "ok refs/heads/topic\n\n", a.helper(remote, "push refs/heads/main:refs/heads/topic"))
+	assert.Len(t, store.packs(), 1)
+
+	b := newRepo(t)
+	b.helper(remote, "list", fmt.Sprintf("fetch %s refs/heads/main", first))
+	assert.True(t, b.git.HasObject(context.Background(), first))
+
+	// Only the new pack is fetched
+	second := a.commit("second")
+	a.run("tag", "-a", "v1", "-m", "v1")
+	tag := a.run("rev-parse", "v1")
+	assert.Equal(t, "ok refs/heads/main\nok refs/tags/v1\n\n", a.helper(remote, "push refs/heads/main:refs/heads/main", "push refs/tags/v1:refs/tags/v1"))
+	require.Len(t, store.packs(), 2)
+	b.helper(remote, "list", fmt.Sprintf("fetch %s refs/heads/main", second), fmt.Sprintf("fetch %s refs/tags/v1", tag))
+	assert.True(t, b.git.HasObject(context.Background(), second))
+	assert.True(t, b.git.HasObject(context.Background(), tag))
+	fetched, err := NewHelper(remote, b.git, "peervault://test/repos/app").fetchedPacks(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, fetched, 2)
+}
+
+func TestFetchRefetchesMissingObjects(t *testing.T) {
+	store := newMemStore()
+	remote := newRemote(t, store)
+	a := newRepo(t)
+	first := a.commit("first")
+	a.helper(remote, "push refs/heads/main:refs/heads/main")
+
+	// A repository that lost the objects of a pack it fetched, as when
+	// the record outlives a clone, gets them again
+	b := newRepo(t)
+	state, _, err := remote.Load(context.Background())
+	require.NoError(t, err)
+	h := NewHelper(remote, b.git, "peervault://test/repos/app")
+	require.NoError(t, h.recordPacks(context.Background(), state.Packs...))
+	b.helper(remote, "list", fmt.Sprintf("fetch %s refs/heads/main", first))
+	assert.True(t, b.git.HasObject(context.Background(), first))
+}
+
+func TestPushRejections(t *testing.T) {
+	store := newMemStore()
+	remote := newRemote(t, store)
+	a := newRepo(t)
+	a.commit("first")
+	a.run("tag", "v1")
+	a.helper(remote, "push refs/heads/main:refs/heads/main", "push refs/tags/v1:refs/tags/v1")
+
+	// b diverges from a
+	b := newRepo(t)
+	b.commit("unrelated")
+	assert.Equal(t, "error refs/heads/main fetch first\n\n", b.helper(remote, "push refs/heads/main:refs/heads/main"))
+	b.helper(remote, "list", fmt.Sprintf("fetch %s refs/heads/main", a.run("rev-parse", "main")))
+	assert.Equal(t, "error refs/heads/main non-fast-forward\n\n", b.helper(remote, "push refs/heads/main:refs/heads/main"))
+	assert.Equal(t, "error refs/tags/v1 already exists\n\n", b.helper(remote, "push refs/heads/main:refs/tags/v1"))
+	assert.Equal(t, "error refs/heads/x src refspec does not match any object\n\n", b.helper(remote, "push refs/heads/nope:refs/heads/x"))
+
+	// Forced updates and fast-forwards are accepted
+	forced := b.run("rev-parse", "main")
+	assert.Equal(t, "ok refs/heads/main\n\n", b.helper(remote, "push +refs/heads/main:refs/heads/main"))
+	b.commit("next")
+	assert.Equal(t, "ok refs/heads/main\n\n", b.helper(remote, "push refs/heads/main:refs/heads/main"))
+	state, _, err := remote.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, b.run("rev-parse", "main"), state.Refs["refs/heads/main"])
+	assert.NotEqual(t, forced, state.Refs["refs/heads/main"])
+
+	// Deleting the branch HEAD points to moves HEAD
+	b.helper(remote, "push refs/heads/main:refs/heads/dev")
+	assert.Equal(t, "ok refs/heads/main\nerror refs/heads/gone remote ref does not exist\n\n", b.helper(remote, "push :refs/heads/main", "push :refs/heads/gone"))
+	state, _, err = remote.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "refs/heads/dev", state.Head)
+	assert.NotContains(t, state.Refs, "refs/heads/main")
+}
+
+func TestUpdateRetriesConflicts(t *testing.T) {
+	store := newMemStore()
+	remote := newRemote(t, store)
+	calls := 0
+	bump := func(state *State) error {
+		calls++
+		state.Refs["refs/heads/main"] = fmt.Sprint(calls)
+		return nil
+	}
+
+	store.conflicts = 2
+	state, err := remote.Update(context.Background(), bump)
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, "3", state.Refs["refs/heads/main"])
+
+	store.conflicts = maxUpdateAttempts
+	_, err = remote.Update(context.Background(), bump)
+	assert.ErrorIs(t, err, ErrConflict)
+	loaded, _, err := remote.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "3", loaded.Refs["refs/heads/main"])
+}
@@ -0,0 +1,209 @@
+// Package gitremote keeps git repositories in PeerVault. It implements
+// git's remote helper protocol for the git-remote-peervault command, so
+// repositories are pushed to and cloned from remotes such as
+// peervault://host:8080/repos/app.
+//
+// A repository is a folder of the store. Every push uploads one packfile
+// holding the objects the remote did not have yet to packs/<sha256>.pack,
+// and refs.json, a JSON document, lists the refs, HEAD and the packs in
+// the order they were pushed. refs.json is replaced only if it did not
+// change since it was read (If-Match), so of two concurrent pushes the
+// later one reads the refs again and retries instead of losing the other's
+// update. Updates that are not fast-forwards are rejected unless forced.
+//
+// Fetches download the packs the local repository has not fetched before
+// and index them with git index-pack. Packs are never rewritten, so a
+// repository with many pushes is cloned in as many downloads.
+package gitremote
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/cli/client"
+	"github.com/Skpow1234/Peervault/internal/directory"
+)
+
+const (
+	// refsName is the JSON document of a repository's refs
+	refsName = "refs.json"
+	// packsDir is the folder of a repository's packfiles
+	packsDir = "packs"
+	// maxUpdateAttempts bounds the retries of a refs update racing with
+	// other pushes
+	maxUpdateAttempts = 10
+)
+
+// ErrConflict is returned when refs.json kept changing while it was updated
+var ErrConflict = errors.New("gitremote: the refs changed during the update")
+
+// Store holds repositories, see client.Client
+type Store interface {
+	GetJSON(ctx context.Context, key string) (*client.JSONDocument, error)
+	PutJSON(ctx context.Context, key string, data []byte, w client.JSONWrite) (*client.JSONDocument, error)
+	UploadDataAt(ctx context.Context, path, name, contentType string, r io.Reader, tags []string, metadata map[string]string) (*client.FileInfo, error)
+	DownloadContent(ctx context.Context, key string, w io.Writer) (int64, error)
+}
+
+// State is the content of refs.json
+type State struct {
+	// Head is the ref HEAD points to
+	Head string `json:"head,omitempty"`
+	// Refs maps ref names to object IDs
+	Refs map[string]string `json:"refs"`
+	// Packs are the names of the packfiles, oldest first
+	Packs []string `json:"packs"`
+}
+
+// Remote is a repository in the store
+type Remote struct {
+	store Store
+	repo  string
+}
+
+// NewRemote returns the repository kept in the folder repo of store
+func NewRemote(store Store, repo string) (*Remote, error) {
+	repo, err := directory.CleanKey(repo)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository %q: %w", repo, err)
+	}
+	return &Remote{store: store, repo: repo}, nil
+}
+
+// ParseURL splits the URL of a remote into the server to connect to and
+// the repository. peervault://host:port/repo connects over HTTP and
+// peervault:///repo to the server of the current CLI profile, which is
+// returned as ""; http(s)://host:port/repo, from peervault::https://...
+// remotes, connects as given.
+func ParseURL(raw string) (server, repo string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid remote URL: %w", err)
+	}
+	switch u.Scheme {
+	case "peervault":
+		if u.Host != "" {
+			server = "http://" + u.Host
+		}
+	case "http", "https":
+		if u.Host == "" {
+			return "", "", fmt.Errorf("invalid remote URL %q: no host", raw)
+		}
+		server = u.Scheme + "://" + u.Host
+	default:
+		return "", "", fmt.Errorf("invalid remote URL %q: expected peervault://host:port/repo or http(s)://host:port/repo", raw)
+	}
+	repo = strings.Trim(u.Path, "/")
+	if repo == "" {
+		return "", "", fmt.Errorf("invalid remote URL %q: no repository", raw)
+	}
+	return server, repo, nil
+}
+
+func (r *Remote) refsKey() string {
+	return r.repo + directory.Separator + refsName
+}
+
+func (r *Remote) packKey(name string) string {
+	return r.repo + directory.Separator + packsDir + directory.Separator + name + ".pack"
+}
+
+// Load returns the state of the repository and its ETag, an empty state
+// and "" before the first push
+func (r *Remote) Load(ctx context.Context) (*State, string, error) {
+	doc, err := r.store.GetJSON(ctx, r.refsKey())
+	if client.IsNotFound(err) {
+		return &State{Refs: map[string]string{}}, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read the refs: %w", err)
+	}
+	state := &State{}
+	if err := json.Unmarshal(doc.Data, state); err != nil {
+		return nil, "", fmt.Errorf("failed to parse the refs: %w", err)
+	}
+	if state.Refs == nil {
+		state.Refs = map[string]string{}
+	}
+	return state, doc.ETag, nil
+}
+
+// Update applies fn to the state of the repository and stores the result
+// if the state did not change meanwhile, calling fn again on the new state
+// otherwise. The state is left alone when fn fails.
+func (r *Remote) Update(ctx context.Context, fn func(*State) error) (*State, error) {
+	for range maxUpdateAttempts {
+		state, etag, err := r.Load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(state); err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(state)
+		if err != nil {
+			return nil, err
+		}
+		_, err = r.store.PutJSON(ctx, r.refsKey(), data, client.JSONWrite{IfMatch: etag, Create: etag == ""})
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusPreconditionFailed {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to write the refs: %w", err)
+		}
+		return state, nil
+	}
+	return nil, ErrConflict
+}
+
+// UploadPack stores the packfile read from pack and returns its name, ""
+// for a pack without objects, which is not stored
+func (r *Remote) UploadPack(ctx context.Context, pack io.Reader) (string, error) {
+	spool, err := os.CreateTemp("", "peervault-pack-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(spool, hash), pack); err != nil {
+		return "", err
+	}
+	// The header is "PACK", the version and the number of objects
+	var header [12]byte
+	if _, err := spool.ReadAt(header[:], 0); err != nil || string(header[:4]) != "PACK" {
+		return "", errors.New("git produced an invalid packfile")
+	}
+	if binary.BigEndian.Uint32(header[8:]) == 0 {
+		return "", nil
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	name := hex.EncodeToString(hash.Sum(nil))
+	if _, err := r.store.UploadDataAt(ctx, r.packKey(name), name+".pack", "application/x-git-packed-objects", spool, nil, nil); err != nil {
+		return "", fmt.Errorf("failed to upload pack %s: %w", name, err)
+	}
+	return name, nil
+}
+
+// DownloadPack writes the packfile name to w
+func (r *Remote) DownloadPack(ctx context.Context, name string, w io.Writer) error {
+	if _, err := r.store.DownloadContent(ctx, r.packKey(name), w); err != nil {
+		return fmt.Errorf("failed to download pack %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+// Package grafana answers the queries of Grafana's JSON datasource plugin
+// with a node's sampled metrics and its access rollups, so dashboards can
+// chart PeerVault without an exporter in between.
+//
+// A target names a metric with optional label selectors, PromQL style:
+//
+//	peers
+//	access_reads{tenant="acme"}
+//	access_bytes_written{peer="*"}
+//
+// Node metrics carry a node label. Access metrics (access_reads,
+// access_writes, ...) are the hourly rollups; selecting key, tenant or peer
+// restricts them to one value, or splits them into a series per value with
+// "*". Without such a selector they sum every access of the node.
+package grafana
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/analytics"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+)
+
+// ErrInvalidQuery is returned for targets and filters that cannot be
+// answered
+var ErrInvalidQuery = errors.New("grafana: invalid query")
+
+// accessPrefix prefixes the names of access metrics
+const accessPrefix = "access_"
+
+// Labels are the labels series carry, besides the node's own
+var Labels = []string{"node", string(analytics.ByKey), string(analytics.ByTenant), string(analytics.ByPeer)}
+
+// MetricSource is where node metrics come from; a fileserver.Server is one
+type MetricSource interface {
+	MetricNames() []string
+	MetricHistory(name string, from, to time.Time) []fileserver.MetricPoint
+}
+
+// Datasource answers queries about one node
+type Datasource struct {
+	// Node is the ID of the node, the value of the node label
+	Node    string
+	Metrics MetricSource
+	// Analytics is nil when the node collects no access analytics
+	Analytics *analytics.Collector
+}
+
+// Datapoint is a value and its time in Unix milliseconds
+type Datapoint [2]float64
+
+// Series is a time series result
+type Series struct {
+	Target     string      `json:"target"`
+	RefID      string      `json:"refId,omitempty"`
+	Datapoints []Datapoint `json:"datapoints"`
+}
+
+// Column is a column of a table result
+type Column struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// Table is a table result
+type Table struct {
+	Type    string   `json:"type"`
+	RefID   string   `json:"refId,omitempty"`
+	Columns []Column `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+}
+
+// Range is the time range of a query
+type Range struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// Target is one query of a panel
+type Target struct {
+	RefID  string `json:"refId"`
+	Target string `json:"target"`
+	// Type is timeserie, the default, or table
+	Type string `json:"type,omitempty"`
+	Hide bool   `json:"hide,omitempty"`
+}
+
+// Filter is an ad hoc filter of a dashboard; only = is supported
+type Filter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// QueryRequest is the body Grafana posts to /query
+type QueryRequest struct {
+	Range         Range    `json:"range"`
+	IntervalMs    int64    `json:"intervalMs,omitempty"`
+	MaxDataPoints int      `json:"maxDataPoints,omitempty"`
+	Targets       []Target `json:"targets"`
+	AdhocFilters  []Filter `json:"adhocFilters,omitempty"`
+}
+
+// series is a result before it is rendered
+type series struct {
+	labels map[string]string
+	points []Datapoint
+	// counter series sum over a range rather than keeping the last value
+	counter bool
+}
+
+// selector is a parsed target
+type selector struct {
+	name   string
+	labels map[string]string
+}
+
+// parseTarget parses name{label="value",...}
+func parseTarget(target string) (selector, error) {
+	target = strings.TrimSpace(target)
+	name, rest, hasLabels := strings.Cut(target, "{")
+	sel := selector{name: strings.TrimSpace(name), labels: make(map[string]string)}
+	if sel.name == "" {
+		return selector{}, fmt.Errorf("%w: target %q names no metric", ErrInvalidQuery, target)
+	}
+	if !hasLabels {
+		return sel, nil
+	}
+	body, ok := strings.CutSuffix(strings.TrimSpace(rest), "}")
+	if !ok {
+		return selector{}, fmt.Errorf("%w: target %q misses a closing brace", ErrInvalidQuery, target)
+	}
+	for _, pair := range strings.Split(body, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !slices.Contains(Labels, key) {
+			return selector{}, fmt.Errorf("%w: target %q selects labels other than %s", ErrInvalidQuery, target, strings.Join(Labels, ", "))
+		}
+		sel.labels[key] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return sel, nil
+}
+
+// String renders a series name like peers{node="a"}
+func (s series) String(name string) string {
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, key := range slices.Sorted(maps.Keys(s.labels)) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", key, s.labels[key])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// Targets returns the metric names a panel can query, in order
+func (d *Datasource) Targets() []string {
+	names := d.Metrics.MetricNames()
+	if d.Analytics != nil {
+		for _, m := range analytics.Metrics() {
+			names = append(names, accessPrefix+string(m))
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// LabelValues returns the values of a label seen on the node, in order
+func (d *Datasource) LabelValues(label string) ([]string, error) {
+	if label == "node" {
+		return []string{d.Node}, nil
+	}
+	if !slices.Contains(Labels, label) {
+		return nil, fmt.Errorf("%w: unknown label %q", ErrInvalidQuery, label)
+	}
+	if d.Analytics == nil {
+		return []string{}, nil
+	}
+	rollups, err := d.Analytics.Query(analytics.Query{Dimension: analytics.Dimension(label)})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	values := []string{}
+	for _, r := range rollups {
+		if !seen[r.Value] {
+			seen[r.Value] = true
+			values = append(values, r.Value)
+		}
+	}
+	slices.Sort(values)
+	return values, nil
+}
+
+// Query answers the targets of q that are not hidden, as Series and Table
+// values in the order of the targets
+func (d *Datasource) Query(q QueryRequest) ([]any, error) {
+	if q.Range.To.IsZero() {
+		q.Range.To = time.Now()
+	}
+	if q.Range.From.IsZero() {
+		q.Range.From = q.Range.To.Add(-time.Hour)
+	}
+	for _, f := range q.AdhocFilters {
+		if f.Operator != "=" || !slices.Contains(Labels, f.Key) {
+			return nil, fmt.Errorf("%w: ad hoc filters must be label = value on %s", ErrInvalidQuery, strings.Join(Labels, ", "))
+		}
+	}
+
+	results := []any{}
+	for _, t := range q.Targets {
+		if t.Hide || strings.TrimSpace(t.Target) == "" {
+			continue
+		}
+		sel, err := parseTarget(t.Target)
+		if err != nil {
+			return nil, err
+		}
+		// Ad hoc filters apply to targets selecting nothing else on their
+		// label, or for key, tenant and peer, on any of them
+		selectsAccess := false
+		for _, label := range Labels[1:] {
+			_, ok := sel.labels[label]
+			selectsAccess = selectsAccess || ok
+		}
+		for _, f := range q.AdhocFilters {
+			if _, ok := sel.labels[f.Key]; !ok && (f.Key == "node" || !selectsAccess) {
+				sel.labels[f.Key] = f.Value
+			}
+		}
+		found, err := d.series(sel, q.Range)
+		if err != nil {
+			return nil, err
+		}
+		switch t.Type {
+		case "table":
+			results = append(results, table(t.RefID, sel.name, found))
+		case "", "timeserie", "timeseries":
+			for _, s := range found {
+				results = append(results, Series{Target: s.String(sel.name), RefID: t.RefID, Datapoints: downsample(s.points, q.MaxDataPoints)})
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown target type %q", ErrInvalidQuery, t.Type)
+		}
+	}
+	return results, nil
+}
+
+// series finds the series sel selects in r
+func (d *Datasource) series(sel selector, r Range) ([]series, error) {
+	if node, ok := sel.labels["node"]; ok && node != "*" && node != d.Node {
+		return nil, nil
+	}
+	name, isAccess := strings.CutPrefix(sel.name, accessPrefix)
+	if !isAccess {
+		// Metrics not sampled yet, or pushed to alerting only, have no points
+		points := d.Metrics.MetricHistory(sel.name, r.From, r.To)
+		s := series{labels: map[string]string{"node": d.Node}, points: make([]Datapoint, len(points))}
+		for i, p := range points {
+			s.points[i] = Datapoint{p.Value, float64(p.Time.UnixMilli())}
+		}
+		return []series{s}, nil
+	}
+
+	metric := analytics.Metric(name)
+	if _, ok := metric.Value(analytics.Counters{}); !ok || d.Analytics == nil {
+		return nil, fmt.Errorf("%w: unknown metric %q", ErrInvalidQuery, sel.name)
+	}
+	q := analytics.Query{Dimension: analytics.ByKey, From: r.From, To: r.To}
+	split := false
+	for _, dim := range []analytics.Dimension{analytics.ByKey, analytics.ByTenant, analytics.ByPeer} {
+		value, ok := sel.labels[string(dim)]
+		if !ok {
+			continue
+		}
+		if q.Dimension != analytics.ByKey || q.Value != "" || split {
+			return nil, fmt.Errorf("%w: select at most one of key, tenant and peer", ErrInvalidQuery)
+		}
+		q.Dimension, split = dim, value == "*"
+		if !split {
+			q.Value = value
+		}
+	}
+	rollups, err := d.Analytics.Query(q)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every series gets a point per bucket of the range, zero when there
+	// was no access
+	size := d.Analytics.BucketSize()
+	var starts []time.Time
+	for t := r.From.Truncate(size); t.Before(r.To); t = t.Add(size) {
+		starts = append(starts, t)
+	}
+	sums := make(map[string]map[int64]int64)
+	for _, rollup := range rollups {
+		group := ""
+		if split {
+			group = rollup.Value
+		}
+		if sums[group] == nil {
+			sums[group] = make(map[int64]int64)
+		}
+		v, _ := metric.Value(rollup.Counters)
+		sums[group][rollup.Start.Unix()] += v
+	}
+	if !split && len(sums) == 0 {
+		sums[""] = nil
+	}
+
+	found := make([]series, 0, len(sums))
+	for _, group := range slices.Sorted(maps.Keys(sums)) {
+		s := series{labels: map[string]string{"node": d.Node}, counter: true, points: make([]Datapoint, len(starts))}
+		switch {
+		case split:
+			s.labels[string(q.Dimension)] = group
+		case q.Value != "":
+			s.labels[string(q.Dimension)] = q.Value
+		}
+		for i, start := range starts {
+			s.points[i] = Datapoint{float64(sums[group][start.Unix()]), float64(start.UnixMilli())}
+		}
+		found = append(found, s)
+	}
+	return found, nil
+}
+
+// table renders one row per series: its labels and its last value, or the
+// sum of an access metric over the range
+func table(refID, name string, found []series) Table {
+	t := Table{Type: "table", RefID: refID, Rows: [][]any{}}
+	var labels []string
+	for _, s := range found {
+		for key := range s.labels {
+			if !slices.Contains(labels, key) {
+				labels = append(labels, key)
+			}
+		}
+	}
+	slices.Sort(labels)
+	for _, key := range labels {
+		t.Columns = append(t.Columns, Column{Text: key, Type: "string"})
+	}
+	t.Columns = append(t.Columns, Column{Text: name, Type: "number"})
+	for _, s := range found {
+		row := make([]any, 0, len(labels)+1)
+		for _, key := range labels {
+			row = append(row, s.labels[key])
+		}
+		var v float64
+		for _, p := range s.points {
+			if s.counter {
+				v += p[0]
+			} else {
+				v = p[0]
+			}
+		}
+		t.Rows = append(t.Rows, append(row, v))
+	}
+	return t
+}
+
+// downsample keeps at most limit points, evenly spaced, always keeping the
+// last one
+func downsample(points []Datapoint, limit int) []Datapoint {
+	if limit <= 0 || len(points) <= limit {
+		return points
+	}
+	step := (len(points) + limit - 1) / limit
+	kept := make([]Datapoint, 0, limit)
+	for i := len(points) - 1; i >= 0; i -= step {
+		kept = append(kept, points[i])
+	}
+	slices.Reverse(kept)
+	return kept
+}
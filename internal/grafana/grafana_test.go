@@ -0,0 +1,135 @@
+package grafana
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/analytics"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMetrics holds sampled node metrics
+type fakeMetrics map[string][]fileserver.MetricPoint
+
+func (m fakeMetrics) MetricNames() []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (m fakeMetrics) MetricHistory(name string, from, to time.Time) []fileserver.MetricPoint {
+	var points []fileserver.MetricPoint
+	for _, p := range m[name] {
+		if !p.Time.Before(from) && !p.Time.After(to) {
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+func newDatasource(now time.Time) *Datasource {
+	metrics := fakeMetrics{"peers": nil}
+	for i := range 10 {
+		metrics["peers"] = append(metrics["peers"], fileserver.MetricPoint{Time: now.Add(time.Duration(i-9) * time.Minute), Value: float64(i)})
+	}
+	c := analytics.NewCollector(analytics.Options{})
+	hour := now.Truncate(time.Hour)
+	c.Record(analytics.Access{Time: hour.Add(-time.Hour), Op: analytics.OpRead, Key: "a.txt", Tenant: "acme", Bytes: 10})
+	c.Record(analytics.Access{Time: hour, Op: analytics.OpRead, Key: "a.txt", Tenant: "acme", Bytes: 10})
+	c.Record(analytics.Access{Time: hour, Op: analytics.OpRead, Key: "b.txt", Tenant: "globex", Bytes: 5})
+	c.Record(analytics.Access{Time: hour, Op: analytics.OpWrite, Key: "b.txt", Peer: "10.0.0.2:3000", Bytes: 5})
+	return &Datasource{Node: "node-1", Metrics: metrics, Analytics: c}
+}
+
+func TestTargetsAndLabels(t *testing.T) {
+	d := newDatasource(time.Now())
+	targets := d.Targets()
+	assert.True(t, slices.IsSorted(targets))
+	assert.Contains(t, targets, "peers")
+	assert.Contains(t, targets, "access_bytes_written")
+
+	values, err := d.LabelValues("tenant")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"acme", "globex"}, values)
+	values, err = d.LabelValues("node")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node-1"}, values)
+	_, err = d.LabelValues("region")
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+}
+
+func TestQueryNodeMetrics(t *testing.T) {
+	now := time.Now()
+	d := newDatasource(now)
+	results, err := d.Query(QueryRequest{
+		Range:         Range{From: now.Add(-5 * time.Minute), To: now},
+		MaxDataPoints: 3,
+		Targets:       []Target{{RefID: "A", Target: "peers"}, {RefID: "B", Target: "peers", Hide: true}},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	s := results[0].(Series)
+	assert.Equal(t, `peers{node="node-1"}`, s.Target)
+	assert.Equal(t, "A", s.RefID)
+	require.Len(t, s.Datapoints, 3, "six points are downsampled to three")
+	assert.Equal(t, Datapoint{9, float64(now.UnixMilli())}, s.Datapoints[2])
+
+	results, err = d.Query(QueryRequest{Targets: []Target{{Target: `peers{node="node-2"}`}}})
+	require.NoError(t, err)
+	assert.Empty(t, results, "a node only answers for itself")
+
+	results, err = d.Query(QueryRequest{Targets: []Target{{Target: "peers", Type: "table"}}})
+	require.NoError(t, err)
+	table := results[0].(Table)
+	assert.Equal(t, []Column{{"node", "string"}, {"peers", "number"}}, table.Columns)
+	assert.Equal(t, [][]any{{"node-1", float64(9)}}, table.Rows, "tables show the last value of a node metric")
+}
+
+func TestQueryAccessRollups(t *testing.T) {
+	now := time.Now()
+	hour := now.Truncate(time.Hour)
+	d := newDatasource(now)
+	r := Range{From: hour.Add(-time.Hour), To: now}
+
+	results, err := d.Query(QueryRequest{Range: r, Targets: []Target{{Target: "access_reads"}}})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	s := results[0].(Series)
+	assert.Equal(t, `access_reads{node="node-1"}`, s.Target)
+	assert.Equal(t, []Datapoint{{1, float64(hour.Add(-time.Hour).UnixMilli())}, {2, float64(hour.UnixMilli())}}, s.Datapoints)
+
+	results, err = d.Query(QueryRequest{Range: r, Targets: []Target{{Target: `access_bytes_read{tenant="*"}`}}})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, `access_bytes_read{node="node-1",tenant="acme"}`, results[0].(Series).Target)
+	assert.Equal(t, `access_bytes_read{node="node-1",tenant="globex"}`, results[1].(Series).Target)
+	assert.Equal(t, Datapoint{0, float64(hour.Add(-time.Hour).UnixMilli())}, results[1].(Series).Datapoints[0], "buckets without accesses are zero")
+
+	// Ad hoc filters narrow targets that select no key, tenant or peer
+	results, err = d.Query(QueryRequest{
+		Range:        r,
+		Targets:      []Target{{Target: "access_total", Type: "table"}, {Target: `access_total{peer="*"}`, Type: "table"}},
+		AdhocFilters: []Filter{{Key: "tenant", Operator: "=", Value: "acme"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, [][]any{{"node-1", "acme", float64(2)}}, results[0].(Table).Rows)
+	assert.Equal(t, [][]any{{"node-1", "10.0.0.2:3000", float64(1)}}, results[1].(Table).Rows)
+
+	for _, q := range []QueryRequest{
+		{Targets: []Target{{Target: "access_latency"}}},
+		{Targets: []Target{{Target: `access_reads{tenant="acme",peer="*"}`}}},
+		{Targets: []Target{{Target: `peers{region="eu"}`}}},
+		{Targets: []Target{{Target: `peers{node="a"`}}},
+		{Targets: []Target{{Target: "peers", Type: "heatmap"}}},
+		{Targets: []Target{{Target: "peers"}}, AdhocFilters: []Filter{{Key: "tenant", Operator: "=~", Value: "a.*"}}},
+	} {
+		_, err := d.Query(q)
+		assert.ErrorIs(t, err, ErrInvalidQuery, "%+v", q)
+	}
+}
@@ -0,0 +1,150 @@
+// Package hashring assigns keys to nodes with consistent hashing. Each node
+// is placed on the ring at a number of virtual node positions proportional
+// to its weight, and a key is owned by the first distinct nodes found
+// walking clockwise from the key's position. Adding or removing a node only
+// moves the keys next to its positions, about 1/N of them, instead of
+// reshuffling everything.
+package hashring
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// DefaultVirtualNodes is the number of ring positions of a node of weight 1
+const DefaultVirtualNodes = 128
+
+// Node is a member of the ring
+type Node struct {
+	ID string `json:"id"`
+	// Weight scales the share of keys the node owns; 1 is the share of an
+	// average node
+	Weight float64 `json:"weight"`
+}
+
+// Ring is an immutable consistent hashing ring; With and Without return
+// modified copies, so the ring before a membership change can be compared
+// with the ring after it
+type Ring struct {
+	vnodes int
+	nodes  map[string]Node
+	points []point
+}
+
+type point struct {
+	hash uint64
+	id   string
+}
+
+// New creates a ring with vnodes positions per unit of weight, zero
+// meaning DefaultVirtualNodes
+func New(vnodes int, nodes ...Node) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+	r := &Ring{vnodes: vnodes, nodes: make(map[string]Node, len(nodes))}
+	for _, n := range nodes {
+		r.nodes[n.ID] = normalize(n)
+	}
+	r.build()
+	return r
+}
+
+// normalize gives nodes without a valid weight the default weight
+func normalize(n Node) Node {
+	if n.Weight <= 0 || math.IsNaN(n.Weight) || math.IsInf(n.Weight, 0) {
+		n.Weight = 1
+	}
+	return n
+}
+
+// build places the virtual nodes. A node's positions depend only on its ID
+// and weight, so the other nodes keep theirs when membership changes.
+func (r *Ring) build() {
+	r.points = r.points[:0]
+	for id, n := range r.nodes {
+		count := max(1, int(math.Round(float64(r.vnodes)*n.Weight)))
+		for i := range count {
+			r.points = append(r.points, point{hash: hash(id + "#" + strconv.Itoa(i)), id: id})
+		}
+	}
+	// Ties between positions are broken by ID so every node builds the
+	// same ring
+	slices.SortFunc(r.points, func(a, b point) int {
+		return cmp.Or(cmp.Compare(a.hash, b.hash), cmp.Compare(a.id, b.id))
+	})
+}
+
+func hash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// With returns a ring that also holds n, or has n's new weight
+func (r *Ring) With(n Node) *Ring {
+	n = normalize(n)
+	if prev, ok := r.nodes[n.ID]; ok && prev == n {
+		return r
+	}
+	nodes := r.Nodes()
+	nodes = slices.DeleteFunc(nodes, func(m Node) bool { return m.ID == n.ID })
+	return New(r.vnodes, append(nodes, n)...)
+}
+
+// Without returns a ring without the node with the given ID
+func (r *Ring) Without(id string) *Ring {
+	if _, ok := r.nodes[id]; !ok {
+		return r
+	}
+	nodes := slices.DeleteFunc(r.Nodes(), func(m Node) bool { return m.ID == id })
+	return New(r.vnodes, nodes...)
+}
+
+// Has reports whether the node is on the ring
+func (r *Ring) Has(id string) bool {
+	_, ok := r.nodes[id]
+	return ok
+}
+
+// Len returns the number of nodes
+func (r *Ring) Len() int { return len(r.nodes) }
+
+// Nodes returns the nodes ordered by ID
+func (r *Ring) Nodes() []Node {
+	nodes := make([]Node, 0, len(r.nodes))
+	for _, n := range r.nodes {
+		nodes = append(nodes, n)
+	}
+	slices.SortFunc(nodes, func(a, b Node) int { return cmp.Compare(a.ID, b.ID) })
+	return nodes
+}
+
+// Owners returns the IDs of the n nodes that own key, in ring order: the
+// first is the primary owner. Fewer are returned when the ring has fewer
+// nodes.
+func (r *Ring) Owners(key string, n int) []string {
+	n = min(n, len(r.nodes))
+	if n <= 0 {
+		return nil
+	}
+	h := hash(key)
+	start, _ := slices.BinarySearchFunc(r.points, h, func(p point, h uint64) int { return cmp.Compare(p.hash, h) })
+
+	owners := make([]string, 0, n)
+	for i := 0; i < len(r.points) && len(owners) < n; i++ {
+		id := r.points[(start+i)%len(r.points)].id
+		if !slices.Contains(owners, id) {
+			owners = append(owners, id)
+		}
+	}
+	return owners
+}
+
+// Owns reports whether the node is one of the n owners of key
+func (r *Ring) Owns(id, key string, n int) bool {
+	return slices.Contains(r.Owners(key, n), id)
+}
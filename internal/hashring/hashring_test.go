@@ -0,0 +1,96 @@
+package hashring
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func keys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+	return keys
+}
+
+func primaries(r *Ring, keys []string) map[string]int {
+	counts := map[string]int{}
+	for _, k := range keys {
+		counts[r.Owners(k, 1)[0]]++
+	}
+	return counts
+}
+
+func TestOwnersAreDistinctAndStable(t *testing.T) {
+	r := New(0, Node{ID: "a"}, Node{ID: "b"}, Node{ID: "c"})
+	owners := r.Owners("file.txt", 2)
+	require.Len(t, owners, 2)
+	assert.NotEqual(t, owners[0], owners[1])
+	assert.Equal(t, owners, New(0, Node{ID: "c"}, Node{ID: "a"}, Node{ID: "b"}).Owners("file.txt", 2),
+		"the ring does not depend on the order nodes joined")
+
+	assert.Len(t, r.Owners("file.txt", 5), 3, "no more owners than nodes")
+	assert.Empty(t, New(0).Owners("file.txt", 3))
+}
+
+func TestWeightsScaleShares(t *testing.T) {
+	r := New(0, Node{ID: "small", Weight: 1}, Node{ID: "large", Weight: 3})
+	counts := primaries(r, keys(20000))
+	share := float64(counts["large"]) / 20000
+	assert.InDelta(t, 0.75, share, 0.05)
+}
+
+func TestAddingANodeMovesOnlyItsShare(t *testing.T) {
+	ks := keys(20000)
+	before := New(0, Node{ID: "a"}, Node{ID: "b"}, Node{ID: "c"})
+	after := before.With(Node{ID: "d"})
+	assert.Equal(t, 3, before.Len(), "With leaves the ring unchanged")
+
+	moved := 0
+	for _, k := range ks {
+		was, is := before.Owners(k, 1)[0], after.Owners(k, 1)[0]
+		if was != is {
+			moved++
+			assert.Equal(t, "d", is, "keys only move to the new node")
+		}
+	}
+	assert.InDelta(t, 0.25, float64(moved)/float64(len(ks)), 0.05)
+
+	// Removing it again restores the original placement
+	restored := after.Without("d")
+	for _, k := range ks[:1000] {
+		assert.Equal(t, before.Owners(k, 2), restored.Owners(k, 2))
+	}
+}
+
+func TestReplicaSetsChangeByOneNode(t *testing.T) {
+	before := New(0, Node{ID: "a"}, Node{ID: "b"}, Node{ID: "c"}, Node{ID: "d"})
+	after := before.Without("b")
+	for _, k := range keys(2000) {
+		was, is := before.Owners(k, 2), after.Owners(k, 2)
+		kept := 0
+		for _, id := range is {
+			if slices.Contains(was, id) {
+				kept++
+			}
+		}
+		if slices.Contains(was, "b") {
+			assert.Equal(t, 1, kept, "only b's copy moves")
+		} else {
+			assert.Equal(t, was, is)
+		}
+	}
+}
+
+func TestWithUpdatesWeight(t *testing.T) {
+	r := New(10, Node{ID: "a"})
+	assert.Same(t, r, r.With(Node{ID: "a", Weight: 1}))
+	r = r.With(Node{ID: "a", Weight: 2})
+	assert.Equal(t, []Node{{ID: "a", Weight: 2}}, r.Nodes())
+	assert.Len(t, r.points, 20)
+	assert.True(t, r.Owns("a", "anything", 1))
+}
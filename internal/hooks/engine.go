@@ -0,0 +1,309 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// wasmPageSize is the size of a page of WebAssembly memory
+const wasmPageSize = 64 << 10
+
+// Stats counts the calls of a hook
+type Stats struct {
+	Name       string `json:"name"`
+	Point      Point  `json:"point"`
+	Calls      uint64 `json:"calls"`
+	Rejections uint64 `json:"rejections"`
+	// Errors counts failed calls, including those timing out
+	Errors   uint64 `json:"errors"`
+	Timeouts uint64 `json:"timeouts"`
+	// Duration is the total run time of the calls
+	Duration  time.Duration `json:"duration"`
+	LastError string        `json:"last_error,omitempty"`
+}
+
+// Engine runs the hooks of a configuration
+type Engine struct {
+	hooks []*compiledHook
+}
+
+// compiledHook is a hook with its compiled module. Each hook has its own
+// runtime, which carries its memory limit.
+type compiledHook struct {
+	Hook
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+
+	calls, rejections, failures, timeouts atomic.Uint64
+	nanos                                 atomic.Int64
+	mu                                    sync.Mutex
+	lastError                             string
+}
+
+// New compiles the modules of the enabled hooks of cfg
+func New(cfg Config) (*Engine, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	e := &Engine{}
+	for _, h := range cfg.Hooks {
+		if h.Disabled {
+			continue
+		}
+		c, err := compile(h)
+		if err != nil {
+			e.Close()
+			return nil, err
+		}
+		e.hooks = append(e.hooks, c)
+	}
+	return e, nil
+}
+
+func compile(h Hook) (*compiledHook, error) {
+	if h.Function == "" {
+		h.Function = DefaultFunction
+	}
+	if h.Timeout == 0 {
+		h.Timeout = DefaultTimeout
+	}
+	if h.MaxMemory == 0 {
+		h.MaxMemory = DefaultMaxMemory
+	}
+	code, err := os.ReadFile(h.Module)
+	if err != nil {
+		return nil, fmt.Errorf("hooks: hook %s: %w", h.Name, err)
+	}
+
+	ctx := context.Background()
+	pages := uint32((h.MaxMemory + wasmPageSize - 1) / wasmPageSize)
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(pages).
+		WithCloseOnContextDone(true))
+	c := &compiledHook{Hook: h, runtime: runtime}
+	if err := c.instantiateHost(ctx); err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("hooks: hook %s: %w", h.Name, err)
+	}
+	if c.module, err = runtime.CompileModule(ctx, code); err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("hooks: hook %s: invalid module: %w", h.Name, err)
+	}
+	if c.module.ExportedFunctions()[h.Function] == nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("hooks: hook %s: module exports no function %q", h.Name, h.Function)
+	}
+	return c, nil
+}
+
+// instantiateHost provides the functions modules import: those of the
+// "peervault" module, and WASI without access to anything of the node
+func (c *compiledHook) instantiateHost(ctx context.Context) error {
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, c.runtime); err != nil {
+		return err
+	}
+	_, err := c.runtime.NewHostModuleBuilder("peervault").
+		NewFunctionBuilder().WithFunc(hostInput).Export("input").
+		NewFunctionBuilder().WithFunc(hostSetMeta).Export("set_meta").
+		NewFunctionBuilder().WithFunc(hostDelMeta).Export("del_meta").
+		NewFunctionBuilder().WithFunc(hostReject).Export("reject").
+		NewFunctionBuilder().WithFunc(hostEmit).Export("emit").
+		NewFunctionBuilder().WithFunc(hostLog).Export("log").
+		Instantiate(ctx)
+	return err
+}
+
+// Has reports whether any enabled hook runs at point
+func (e *Engine) Has(point Point) bool {
+	for _, h := range e.hooks {
+		if h.Point == point {
+			return true
+		}
+	}
+	return false
+}
+
+// Run runs the hooks of point that apply to the key of in, in the order
+// they are configured, each seeing the metadata as the previous ones left
+// it. A rejection stops the run with ErrRejected. Post-store hooks run
+// after the fact: their rejections and failures are only logged.
+func (e *Engine) Run(ctx context.Context, point Point, in Input) (Result, error) {
+	in.Point = point
+	res := Result{Metadata: in.Metadata}
+	for _, h := range e.hooks {
+		if !h.applies(point, in.Key) {
+			continue
+		}
+		in.Metadata = res.Metadata
+		st, err := h.call(ctx, in)
+		if err != nil {
+			slog.Warn("hook failed", "hook", h.Name, "point", point, "key", in.Key, "error", err)
+			if h.FailClosed && point != PostStore {
+				return res, fmt.Errorf("%w by hook %s: %v", ErrRejected, h.Name, err)
+			}
+			continue
+		}
+		res.Metadata = st.meta
+		res.Events = append(res.Events, st.events...)
+		if st.rejected {
+			h.rejections.Add(1)
+			slog.Info("hook rejected request", "hook", h.Name, "point", point, "key", in.Key, "reason", st.reason)
+			if point != PostStore {
+				return res, fmt.Errorf("%w by hook %s: %s", ErrRejected, h.Name, st.reason)
+			}
+		}
+	}
+	return res, nil
+}
+
+// call runs the hook on in in a fresh instance of its module
+func (c *compiledHook) call(ctx context.Context, in Input) (*callState, error) {
+	input, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	st := &callState{hook: c.Name, key: in.Key, input: input, meta: maps.Clone(in.Metadata)}
+
+	start := time.Now()
+	callCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	callCtx = context.WithValue(callCtx, stateKey{}, st)
+	err = c.run(callCtx)
+	c.calls.Add(1)
+	c.nanos.Add(int64(time.Since(start)))
+	if err != nil {
+		if errors.Is(callCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			c.timeouts.Add(1)
+			err = fmt.Errorf("timed out after %s", c.Timeout)
+		}
+		c.failures.Add(1)
+		c.mu.Lock()
+		c.lastError = err.Error()
+		c.mu.Unlock()
+		return nil, err
+	}
+	return st, nil
+}
+
+func (c *compiledHook) run(ctx context.Context) error {
+	// Anonymous instances can run side by side
+	mod, err := c.runtime.InstantiateModule(ctx, c.module,
+		wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+	if err != nil {
+		return err
+	}
+	defer mod.Close(context.Background())
+	_, err = mod.ExportedFunction(c.Function).Call(ctx)
+	return err
+}
+
+// Stats returns the counters of the enabled hooks in the order they are
+// configured
+func (e *Engine) Stats() []Stats {
+	stats := make([]Stats, len(e.hooks))
+	for i, h := range e.hooks {
+		h.mu.Lock()
+		lastError := h.lastError
+		h.mu.Unlock()
+		stats[i] = Stats{
+			Name:       h.Name,
+			Point:      h.Point,
+			Calls:      h.calls.Load(),
+			Rejections: h.rejections.Load(),
+			Errors:     h.failures.Load(),
+			Timeouts:   h.timeouts.Load(),
+			Duration:   time.Duration(h.nanos.Load()),
+			LastError:  lastError,
+		}
+	}
+	return stats
+}
+
+// Close releases the compiled modules
+func (e *Engine) Close() error {
+	var errs []error
+	for _, h := range e.hooks {
+		errs = append(errs, h.runtime.Close(context.Background()))
+	}
+	e.hooks = nil
+	return errors.Join(errs...)
+}
+
+// callState is what the host functions of a call read and change
+type callState struct {
+	hook   string
+	key    string
+	input  []byte
+	meta   map[string]string
+	reason string
+	// rejected is set by reject, whose reason may be empty
+	rejected bool
+	events   []Event
+}
+
+type stateKey struct{}
+
+func state(ctx context.Context) *callState {
+	return ctx.Value(stateKey{}).(*callState)
+}
+
+// read returns length bytes of the module's memory at ptr; reads out of
+// bounds trap
+func read(m api.Module, ptr, length uint32) string {
+	data, ok := m.Memory().Read(ptr, length)
+	if !ok {
+		panic(fmt.Errorf("hooks: read of %d bytes at %d out of bounds", length, ptr))
+	}
+	return string(data)
+}
+
+func hostInput(ctx context.Context, m api.Module, ptr, capacity uint32) uint32 {
+	input := state(ctx).input
+	if uint32(len(input)) <= capacity && !m.Memory().Write(ptr, input) {
+		panic(fmt.Errorf("hooks: write of %d bytes at %d out of bounds", len(input), ptr))
+	}
+	return uint32(len(input))
+}
+
+func hostSetMeta(ctx context.Context, m api.Module, kptr, klen, vptr, vlen uint32) {
+	st := state(ctx)
+	if st.meta == nil {
+		st.meta = make(map[string]string)
+	}
+	st.meta[read(m, kptr, klen)] = read(m, vptr, vlen)
+}
+
+func hostDelMeta(ctx context.Context, m api.Module, kptr, klen uint32) {
+	delete(state(ctx).meta, read(m, kptr, klen))
+}
+
+func hostReject(ctx context.Context, m api.Module, ptr, length uint32) {
+	st := state(ctx)
+	st.rejected = true
+	st.reason = read(m, ptr, length)
+}
+
+func hostEmit(ctx context.Context, m api.Module, tptr, tlen, dptr, dlen uint32) {
+	st := state(ctx)
+	if len(st.events) >= maxEvents {
+		panic(fmt.Errorf("hooks: more than %d events emitted", maxEvents))
+	}
+	st.events = append(st.events, Event{Hook: st.hook, Type: read(m, tptr, tlen), Key: st.key, Data: read(m, dptr, dlen)})
+}
+
+func hostLog(ctx context.Context, m api.Module, ptr, length uint32) {
+	st := state(ctx)
+	slog.Info(read(m, ptr, length), "hook", st.hook, "key", st.key)
+}
@@ -0,0 +1,184 @@
+// Package hooks runs operator-supplied WebAssembly modules at points of
+// the lifecycle of a request: before and after a file is stored, before
+// it is read and before it is deleted. A hook reads the request, and may
+// change the metadata of the file, reject the request or emit events.
+// Each call runs in a fresh instance of the module, bounded in time and
+// memory.
+//
+// Modules import their functions from the "peervault" module and export
+// a function without parameters or results, "hook" unless configured
+// otherwise:
+//
+//	input(ptr, cap i32) i32                 // request as JSON; returns its length, copied when it fits
+//	set_meta(kptr, klen, vptr, vlen i32)    // set a metadata attribute (pre-store)
+//	del_meta(kptr, klen i32)                // remove a metadata attribute (pre-store)
+//	reject(ptr, len i32)                    // reject the request with a reason
+//	emit(tptr, tlen, dptr, dlen i32)        // publish an event of a type with data
+//	log(ptr, len i32)                       // write a line to the node's log
+//
+// Modules built for WASI, e.g. by TinyGo or Rust, can be used as they are;
+// they get no access to files, the network or the clock.
+package hooks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Point is where in the lifecycle of a request a hook runs
+type Point string
+
+const (
+	// PreStore runs before a file is stored; hooks may change its metadata
+	// or reject it
+	PreStore Point = "pre-store"
+	// PostStore runs after a file is stored
+	PostStore Point = "post-store"
+	// PreGet runs before a file is read; hooks may reject the read
+	PreGet Point = "pre-get"
+	// OnDelete runs before a file is deleted; hooks may reject the deletion
+	OnDelete Point = "on-delete"
+)
+
+// Points are every point hooks can run at
+var Points = []Point{PreStore, PostStore, PreGet, OnDelete}
+
+const (
+	// DefaultFunction is the function of a module a hook calls
+	DefaultFunction = "hook"
+	// DefaultTimeout bounds the run time of a hook call
+	DefaultTimeout = 100 * time.Millisecond
+	// DefaultMaxMemory bounds the memory of a hook instance in bytes
+	DefaultMaxMemory = 16 << 20
+	// maxEvents bounds the events a hook call may emit
+	maxEvents = 16
+)
+
+// ErrRejected is returned for requests a hook rejected
+var ErrRejected = errors.New("hooks: rejected")
+
+// hookName matches the names of hooks, which appear in metric names
+var hookName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Hook attaches a module to a lifecycle point
+type Hook struct {
+	Name     string `json:"name" yaml:"name"`
+	Point    Point  `json:"point" yaml:"point"`
+	Disabled bool   `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	// Module is the path of the .wasm file, relative to the hooks file
+	Module string `json:"module" yaml:"module"`
+	// Function the hook calls; defaults to DefaultFunction
+	Function string `json:"function,omitempty" yaml:"function,omitempty"`
+	// Prefix restricts the hook to keys starting with it
+	Prefix string `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+	// Timeout bounds the run time of a call; defaults to DefaultTimeout
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// MaxMemory bounds the memory of an instance in bytes, rounded up to
+	// 64 KiB pages; defaults to DefaultMaxMemory
+	MaxMemory int64 `json:"max_memory,omitempty" yaml:"max_memory,omitempty"`
+	// FailClosed rejects requests when the hook fails or times out;
+	// failures are only logged otherwise
+	FailClosed bool `json:"fail_closed,omitempty" yaml:"fail_closed,omitempty"`
+}
+
+// applies reports whether the hook runs on key at point
+func (h *Hook) applies(point Point, key string) bool {
+	return !h.Disabled && h.Point == point && strings.HasPrefix(key, h.Prefix)
+}
+
+// Config is a set of hooks
+type Config struct {
+	Hooks []Hook `json:"hooks" yaml:"hooks"`
+}
+
+// Validate checks the names and points of the hooks
+func (c *Config) Validate() error {
+	names := make(map[string]bool)
+	for _, h := range c.Hooks {
+		if !hookName.MatchString(h.Name) {
+			return fmt.Errorf("hooks: invalid hook name %q: use letters, digits, - and _", h.Name)
+		}
+		if names[h.Name] {
+			return fmt.Errorf("hooks: duplicate hook %q", h.Name)
+		}
+		names[h.Name] = true
+		if !validPoint(h.Point) {
+			return fmt.Errorf("hooks: hook %s: unknown point %q", h.Name, h.Point)
+		}
+		if h.Module == "" {
+			return fmt.Errorf("hooks: hook %s: module is required", h.Name)
+		}
+		if h.Timeout < 0 || h.MaxMemory < 0 {
+			return fmt.Errorf("hooks: hook %s: timeout and max_memory must not be negative", h.Name)
+		}
+	}
+	return nil
+}
+
+func validPoint(p Point) bool {
+	for _, point := range Points {
+		if p == point {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadConfig reads hooks from a YAML or JSON file, which holds either
+// {"hooks": [...]} or a bare list of hooks. Relative module paths are
+// resolved against the directory of the file.
+func LoadConfig(path string) (Config, error) {
+	var c Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	unmarshal := yaml.Unmarshal
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		unmarshal = json.Unmarshal
+	}
+	if err := unmarshal(data, &c); err != nil {
+		if err := unmarshal(data, &c.Hooks); err != nil {
+			return c, fmt.Errorf("hooks: invalid hooks %s: %w", path, err)
+		}
+	}
+	for i := range c.Hooks {
+		if m := c.Hooks[i].Module; m != "" && !filepath.IsAbs(m) {
+			c.Hooks[i].Module = filepath.Join(filepath.Dir(path), m)
+		}
+	}
+	return c, c.Validate()
+}
+
+// Input is a request as hooks see it, handed to them as JSON
+type Input struct {
+	Point    Point             `json:"point"`
+	Key      string            `json:"key"`
+	Size     int64             `json:"size,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Event is an event a hook emitted
+type Event struct {
+	Hook string `json:"hook"`
+	Type string `json:"type"`
+	Key  string `json:"key"`
+	Data string `json:"data,omitempty"`
+}
+
+// Result is the outcome of running the hooks of a point on a request
+type Result struct {
+	// Metadata is the metadata of the request after the hooks changed it
+	Metadata map[string]string
+	// Events the hooks emitted, in the order they ran
+	Events []Event
+}
@@ -0,0 +1,279 @@
+package hooks
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The tests build their modules by hand: a WebAssembly module is a short
+// list of sections, and these need only a few instructions.
+
+// Function types of the test modules
+const (
+	typeVoid     = 0 // () -> ()
+	typeTwo      = 1 // (i32, i32) -> ()
+	typeFour     = 2 // (i32, i32, i32, i32) -> ()
+	typeTwoToOne = 3 // (i32, i32) -> i32
+)
+
+type wasmImport struct {
+	name string
+	typ  byte
+}
+
+type wasmData struct {
+	offset int64
+	data   string
+}
+
+func uleb(v uint64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			out = append(out, b|0x80)
+			continue
+		}
+		return append(out, b)
+	}
+}
+
+func sleb(v int64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func name(s string) []byte { return append(uleb(uint64(len(s))), s...) }
+
+func section(id byte, items ...[]byte) []byte {
+	content := uleb(uint64(len(items)))
+	for _, item := range items {
+		content = append(content, item...)
+	}
+	return append(append([]byte{id}, uleb(uint64(len(content)))...), content...)
+}
+
+// i32 pushes a constant
+func i32(v int64) []byte { return append([]byte{0x41}, sleb(v)...) }
+
+// call calls a function by index
+func call(index int) []byte { return append([]byte{0x10}, uleb(uint64(index))...) }
+
+func code(parts ...[]byte) []byte {
+	var body []byte
+	for _, p := range parts {
+		body = append(body, p...)
+	}
+	return body
+}
+
+// module builds a module importing functions from "peervault", with one
+// page of memory holding data and a function "hook" running body
+func module(imports []wasmImport, data []wasmData, body []byte) []byte {
+	out := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	out = append(out, section(1,
+		[]byte{0x60, 0x00, 0x00},
+		[]byte{0x60, 0x02, 0x7f, 0x7f, 0x00},
+		[]byte{0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x00},
+		[]byte{0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f},
+	)...)
+	var entries [][]byte
+	for _, imp := range imports {
+		entries = append(entries, append(append(name("peervault"), name(imp.name)...), 0x00, imp.typ))
+	}
+	out = append(out, section(2, entries...)...)
+	out = append(out, section(3, []byte{typeVoid})...)
+	out = append(out, section(5, []byte{0x00, 0x01})...)
+	out = append(out, section(7,
+		append(name("hook"), 0x00, byte(len(imports))),
+		append(name("memory"), 0x02, 0x00),
+	)...)
+	fn := append([]byte{0x00}, body...)
+	fn = append(fn, 0x0b)
+	out = append(out, section(10, append(uleb(uint64(len(fn))), fn...))...)
+	var segments [][]byte
+	for _, d := range data {
+		segment := append([]byte{0x00}, i32(d.offset)...)
+		segment = append(segment, 0x0b)
+		segments = append(segments, append(segment, name(d.data)...))
+	}
+	return append(out, section(11, segments...)...)
+}
+
+// writeModule writes a module to a file in dir and returns its path
+func writeModule(t *testing.T, dir, file string, wasm []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, file)
+	require.NoError(t, os.WriteFile(path, wasm, 0644))
+	return path
+}
+
+// rejectModule rejects every request with reason
+func rejectModule(reason string) []byte {
+	return module(
+		[]wasmImport{{"reject", typeTwo}},
+		[]wasmData{{0, reason}},
+		code(i32(0), i32(int64(len(reason))), call(0)),
+	)
+}
+
+func TestRunMetadataAndRejections(t *testing.T) {
+	dir := t.TempDir()
+	owner := writeModule(t, dir, "owner.wasm", module(
+		[]wasmImport{{"set_meta", typeFour}, {"del_meta", typeTwo}},
+		[]wasmData{{0, "ownerbobdraft"}},
+		code(i32(0), i32(5), i32(5), i32(3), call(0), i32(8), i32(5), call(1)),
+	))
+	deny := writeModule(t, dir, "deny.wasm", rejectModule("no tmp files"))
+
+	e, err := New(Config{Hooks: []Hook{
+		{Name: "owner", Point: PreStore, Module: owner},
+		{Name: "deny-tmp", Point: PreStore, Module: deny, Prefix: "tmp/"},
+		{Name: "audit", Point: PostStore, Module: deny},
+		{Name: "off", Point: PreGet, Module: deny, Disabled: true},
+	}})
+	require.NoError(t, err)
+	defer e.Close()
+	ctx := context.Background()
+
+	res, err := e.Run(ctx, PreStore, Input{Key: "docs/a.txt", Metadata: map[string]string{"draft": "yes", "lang": "en"}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"owner": "bob", "lang": "en"}, res.Metadata)
+
+	_, err = e.Run(ctx, PreStore, Input{Key: "tmp/a.txt"})
+	assert.ErrorIs(t, err, ErrRejected)
+	assert.ErrorContains(t, err, "deny-tmp: no tmp files")
+
+	// Post-store hooks cannot undo the store
+	_, err = e.Run(ctx, PostStore, Input{Key: "tmp/a.txt"})
+	assert.NoError(t, err)
+
+	// Disabled hooks are not compiled
+	assert.False(t, e.Has(PreGet))
+	_, err = e.Run(ctx, PreGet, Input{Key: "tmp/a.txt"})
+	assert.NoError(t, err)
+
+	stats := e.Stats()
+	require.Len(t, stats, 3)
+	assert.Equal(t, uint64(2), stats[0].Calls)
+	assert.Equal(t, uint64(1), stats[1].Calls)
+	assert.Equal(t, uint64(1), stats[1].Rejections)
+	assert.Equal(t, uint64(1), stats[2].Rejections)
+}
+
+func TestRunInputAndEvents(t *testing.T) {
+	dir := t.TempDir()
+	// emit("tagged", input) with the input read to the start of memory
+	tagger := writeModule(t, dir, "tagger.wasm", module(
+		[]wasmImport{{"input", typeTwoToOne}, {"emit", typeFour}},
+		[]wasmData{{2048, "tagged"}},
+		code(i32(2048), i32(6), i32(0), i32(0), i32(1024), call(0), call(1)),
+	))
+	e, err := New(Config{Hooks: []Hook{{Name: "tagger", Point: OnDelete, Module: tagger}}})
+	require.NoError(t, err)
+	defer e.Close()
+
+	res, err := e.Run(context.Background(), OnDelete, Input{Key: "a.txt", Size: 3})
+	require.NoError(t, err)
+	require.Len(t, res.Events, 1)
+	assert.Equal(t, "tagger", res.Events[0].Hook)
+	assert.Equal(t, "tagged", res.Events[0].Type)
+	assert.Equal(t, "a.txt", res.Events[0].Key)
+	assert.JSONEq(t, `{"point":"on-delete","key":"a.txt","size":3}`, res.Events[0].Data)
+}
+
+func TestRunLimits(t *testing.T) {
+	dir := t.TempDir()
+	spin := writeModule(t, dir, "spin.wasm", module(nil, nil, []byte{0x03, 0x40, 0x0c, 0x00, 0x0b}))
+	// Rejects with "oom" when growing the memory by 100 pages fails
+	grow := writeModule(t, dir, "grow.wasm", module(
+		[]wasmImport{{"reject", typeTwo}},
+		[]wasmData{{0, "oom"}},
+		code(i32(100), []byte{0x40, 0x00}, i32(-1), []byte{0x46, 0x04, 0x40}, i32(0), i32(3), call(0), []byte{0x0b}),
+	))
+
+	e, err := New(Config{Hooks: []Hook{
+		{Name: "spin", Point: PreGet, Module: spin, Timeout: 50 * time.Millisecond},
+		{Name: "spin-closed", Point: OnDelete, Module: spin, Timeout: 50 * time.Millisecond, FailClosed: true},
+		{Name: "grow", Point: PreStore, Module: grow, MaxMemory: 1 << 20},
+		{Name: "grow-unbounded", Point: PostStore, Module: grow},
+	}})
+	require.NoError(t, err)
+	defer e.Close()
+	ctx := context.Background()
+
+	// A hook that fails lets the request through unless it fails closed
+	_, err = e.Run(ctx, PreGet, Input{Key: "a"})
+	assert.NoError(t, err)
+	_, err = e.Run(ctx, OnDelete, Input{Key: "a"})
+	assert.ErrorIs(t, err, ErrRejected)
+	assert.ErrorContains(t, err, "timed out")
+
+	_, err = e.Run(ctx, PreStore, Input{Key: "a"})
+	assert.ErrorContains(t, err, "grow: oom")
+
+	stats := e.Stats()
+	assert.Equal(t, uint64(1), stats[0].Timeouts)
+	assert.Equal(t, uint64(1), stats[0].Errors)
+	assert.Contains(t, stats[0].LastError, "timed out")
+	assert.Equal(t, uint64(1), stats[2].Rejections)
+
+	// 100 pages fit in the default limit
+	_, err = e.Run(ctx, PostStore, Input{Key: "a"})
+	require.NoError(t, err)
+	assert.Zero(t, e.Stats()[3].Rejections)
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeModule(t, dir, "deny.wasm", rejectModule("no"))
+	path := filepath.Join(dir, "hooks.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+hooks:
+  - name: deny
+    point: pre-get
+    module: deny.wasm
+    timeout: 20ms
+`), 0644))
+	cfg, err := LoadConfig(path)
+	require.NoError(t, err)
+	require.Len(t, cfg.Hooks, 1)
+	assert.Equal(t, filepath.Join(dir, "deny.wasm"), cfg.Hooks[0].Module)
+	assert.Equal(t, 20*time.Millisecond, cfg.Hooks[0].Timeout)
+	e, err := New(cfg)
+	require.NoError(t, err)
+	defer e.Close()
+	_, err = e.Run(context.Background(), PreGet, Input{Key: "a"})
+	assert.ErrorIs(t, err, ErrRejected)
+
+	invalid := []struct {
+		hooks []Hook
+		err   string
+	}{
+		{[]Hook{{Name: "a b", Point: PreGet, Module: "m.wasm"}}, "invalid hook name"},
+		{[]Hook{{Name: "a", Point: "post-get", Module: "m.wasm"}}, "unknown point"},
+		{[]Hook{{Name: "a", Point: PreGet}}, "module is required"},
+		{[]Hook{{Name: "a", Point: PreGet, Module: "m.wasm"}, {Name: "a", Point: PreStore, Module: "m.wasm"}}, "duplicate hook"},
+	}
+	for _, tt := range invalid {
+		c := Config{Hooks: tt.hooks}
+		assert.ErrorContains(t, c.Validate(), tt.err)
+	}
+
+	_, err = New(Config{Hooks: []Hook{{Name: "a", Point: PreGet, Module: writeModule(t, dir, "bad.wasm", []byte("not wasm"))}}})
+	assert.ErrorContains(t, err, "invalid module")
+}
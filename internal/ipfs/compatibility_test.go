@@ -288,11 +288,15 @@ func TestIPFSCompatibility_ResolvePath(t *testing.T) {
 	ctx := context.Background()
 
 	// Test valid IPFS path
-	path := "/ipfs/QmTestHash"
+	path := "/ipfs/QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG"
 	cid, err := ic.ResolvePath(ctx, path)
 	assert.NoError(t, err)
 	assert.NotNil(t, cid)
-	assert.Equal(t, "QmTestHash", cid.Hash)
+	assert.Equal(t, "12209d6c2be50f706953479ab9df2ce3edca90b68053c00b3004b7f0accbe1e8eedf", cid.Hash)
+
+	// Paths to anything but a CID are refused
+	_, err = ic.ResolvePath(ctx, "/ipfs/QmTestHash")
+	assert.Error(t, err)
 
 	// Test invalid path
 	invalidPath := "/invalid/path"
@@ -0,0 +1,115 @@
+// Package jsondoc stores small structured records as JSON documents. It
+// normalizes documents, applies JSON Patch (RFC 6902) and JSON Merge Patch
+// (RFC 7396) updates, checks ETag preconditions for optimistic concurrency
+// and validates documents against JSON Schemas bound to key prefixes.
+package jsondoc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/etag"
+)
+
+// Content types of documents and of the patches applied to them
+const (
+	ContentType    = "application/json"
+	PatchType      = "application/json-patch+json"
+	MergePatchType = "application/merge-patch+json"
+)
+
+// MaxSize is the largest document, or patch, in bytes
+const MaxSize = 1 << 20
+
+var (
+	// ErrInvalid is returned for documents and patches that are not JSON
+	ErrInvalid = errors.New("jsondoc: invalid JSON")
+	// ErrPatch is returned for patches that cannot be applied
+	ErrPatch = errors.New("jsondoc: patch cannot be applied")
+	// ErrTestFailed is returned when a test operation of a patch fails
+	ErrTestFailed = errors.New("jsondoc: patch test failed")
+	// ErrPrecondition is returned when a document does not have the ETag
+	// a request expected
+	ErrPrecondition = errors.New("jsondoc: precondition failed")
+	// ErrNotDocument is returned for stored files that are not JSON
+	ErrNotDocument = errors.New("jsondoc: not a JSON document")
+)
+
+// IsDocument reports whether a content type is JSON
+func IsDocument(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	return mediaType == ContentType || strings.HasSuffix(mediaType, "+json")
+}
+
+// Normalize checks that data is one JSON value and returns it compacted,
+// with object keys sorted, so equal documents get equal ETags
+func Normalize(data []byte) ([]byte, error) {
+	v, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return encode(v)
+}
+
+// decode parses one JSON value, keeping numbers as they were written
+func decode(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("%w: data after the JSON value", ErrInvalid)
+	}
+	return v, nil
+}
+
+func encode(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Condition is what a write expects of the stored document, from the
+// If-Match and If-None-Match headers
+type Condition struct {
+	// IfMatch lists ETags the document must have one of; "*" matches any
+	// existing document
+	IfMatch []string
+	// IfNoneMatch requires that no document exists
+	IfNoneMatch bool
+}
+
+// Check checks the condition against the ETag of the stored document,
+// exists telling whether there is one
+func (c Condition) Check(etag string, exists bool) error {
+	if c.IfNoneMatch && exists {
+		return fmt.Errorf("%w: the document exists", ErrPrecondition)
+	}
+	if len(c.IfMatch) > 0 {
+		if !exists {
+			return fmt.Errorf("%w: the document does not exist", ErrPrecondition)
+		}
+		if !slices.Contains(c.IfMatch, "*") && !slices.Contains(c.IfMatch, etag) {
+			return fmt.Errorf("%w: the document changed", ErrPrecondition)
+		}
+	}
+	return nil
+}
+
+// ParseETags parses the entity tags of an If-Match or If-None-Match
+// header, see etag.Parse
+func ParseETags(header string) []string {
+	return etag.Parse(header)
+}
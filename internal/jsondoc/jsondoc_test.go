@@ -0,0 +1,152 @@
+package jsondoc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	doc, err := Normalize([]byte("{\n  \"b\": 1.50, \"a\": [true, null, \"<x>\"]\n}"))
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":[true,null,"<x>"],"b":1.50}`, string(doc), "keys are sorted and numbers kept as written")
+
+	for _, bad := range []string{"", "{", `{"a":1} {}`, "nope"} {
+		_, err := Normalize([]byte(bad))
+		assert.ErrorIs(t, err, ErrInvalid, bad)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	doc := []byte(`{"foo":"bar","list":[1,2,3],"nested":{"a/b":1,"m~n":2}}`)
+	cases := []struct {
+		name, patch, want string
+	}{
+		{"add member", `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar","list":[1,2,3],"nested":{"a/b":1,"m~n":2}}`},
+		{"insert element", `[{"op":"add","path":"/list/1","value":9}]`, `{"foo":"bar","list":[1,9,2,3],"nested":{"a/b":1,"m~n":2}}`},
+		{"append element", `[{"op":"add","path":"/list/-","value":4}]`, `{"foo":"bar","list":[1,2,3,4],"nested":{"a/b":1,"m~n":2}}`},
+		{"remove escaped", `[{"op":"remove","path":"/nested/a~1b"},{"op":"remove","path":"/nested/m~0n"}]`, `{"foo":"bar","list":[1,2,3],"nested":{}}`},
+		{"replace element", `[{"op":"replace","path":"/list/0","value":"one"}]`, `{"foo":"bar","list":["one",2,3],"nested":{"a/b":1,"m~n":2}}`},
+		{"replace root", `[{"op":"replace","path":"","value":[]}]`, `[]`},
+		{"move", `[{"op":"move","from":"/foo","path":"/nested/foo"}]`, `{"list":[1,2,3],"nested":{"a/b":1,"foo":"bar","m~n":2}}`},
+		{"copy", `[{"op":"copy","from":"/list","path":"/copy"},{"op":"add","path":"/copy/-","value":4}]`, `{"copy":[1,2,3,4],"foo":"bar","list":[1,2,3],"nested":{"a/b":1,"m~n":2}}`},
+		{"test passes", `[{"op":"test","path":"/list/2","value":3.0},{"op":"remove","path":"/list"}]`, `{"foo":"bar","nested":{"a/b":1,"m~n":2}}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := Patch(doc, []byte(c.patch))
+			require.NoError(t, err)
+			assert.Equal(t, c.want, string(got))
+		})
+	}
+
+	_, err := Patch(doc, []byte(`[{"op":"remove","path":"/list"},{"op":"test","path":"/foo","value":"baz"}]`))
+	assert.ErrorIs(t, err, ErrTestFailed)
+	for _, bad := range []string{
+		`[{"op":"remove","path":"/missing"}]`,
+		`[{"op":"add","path":"/list/7","value":1}]`,
+		`[{"op":"replace","path":"/list/-","value":1}]`,
+		`[{"op":"add","path":"/foo/x","value":1}]`,
+		`[{"op":"move","from":"/nested","path":"/nested/x"}]`,
+		`[{"op":"add","path":"/x"}]`,
+		`[{"op":"frobnicate","path":"/x"}]`,
+		`[{"op":"remove","path":"x"}]`,
+	} {
+		_, err := Patch(doc, []byte(bad))
+		assert.ErrorIs(t, err, ErrPatch, bad)
+	}
+	_, err = Patch(doc, []byte(`{"op":"add"}`))
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestMergePatch(t *testing.T) {
+	// The example of RFC 7396
+	doc := []byte(`{"title":"Goodbye!","author":{"givenName":"John","familyName":"Doe"},"tags":["example","sample"],"content":"This will be unchanged"}`)
+	patch := []byte(`{"title":"Hello!","phoneNumber":"+01-123-456-7890","author":{"familyName":null},"tags":["example"]}`)
+	got, err := MergePatch(doc, patch)
+	require.NoError(t, err)
+	assert.Equal(t, `{"author":{"givenName":"John"},"content":"This will be unchanged","phoneNumber":"+01-123-456-7890","tags":["example"],"title":"Hello!"}`, string(got))
+
+	got, err = MergePatch([]byte(`{"a":1}`), []byte(`["replaced"]`))
+	require.NoError(t, err)
+	assert.Equal(t, `["replaced"]`, string(got))
+}
+
+func TestCondition(t *testing.T) {
+	assert.NoError(t, Condition{}.Check("", false))
+	assert.NoError(t, Condition{IfMatch: []string{"abc"}}.Check("abc", true))
+	assert.NoError(t, Condition{IfMatch: []string{"*"}}.Check("abc", true))
+	assert.NoError(t, Condition{IfNoneMatch: true}.Check("", false))
+	assert.ErrorIs(t, Condition{IfMatch: []string{"abc"}}.Check("def", true), ErrPrecondition)
+	assert.ErrorIs(t, Condition{IfMatch: []string{"*"}}.Check("", false), ErrPrecondition)
+	assert.ErrorIs(t, Condition{IfNoneMatch: true}.Check("abc", true), ErrPrecondition)
+
+	assert.Equal(t, []string{"abc", "def", "*"}, ParseETags(`"abc", W/"def" ,*`))
+}
+
+func TestSchema(t *testing.T) {
+	schema, err := CompileSchema([]byte(`{
+		"type": "object",
+		"required": ["name", "age"],
+		"additionalProperties": false,
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "pattern": "^[A-Z]"},
+			"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+			"role": {"enum": ["admin", "user"]},
+			"tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true, "maxItems": 3}
+		}
+	}`))
+	require.NoError(t, err)
+
+	assert.NoError(t, schema.Validate([]byte(`{"name":"Ada","age":36,"role":"admin","tags":["a","b"]}`)))
+
+	err = schema.Validate([]byte(`{"name":"ada","age":36.5,"role":"root","tags":["a","a",1],"extra":true}`))
+	var schemaErr *SchemaError
+	require.ErrorAs(t, err, &schemaErr)
+	assert.ErrorIs(t, err, ErrSchema)
+	assert.Equal(t, []string{
+		"/age: must be integer",
+		"/extra: is not allowed",
+		"/name: must match ^[A-Z]",
+		"/role: must be one of the enumerated values",
+		"/tags: items must be unique",
+		"/tags/2: must be string",
+	}, schemaErr.Violations)
+
+	err = schema.Validate([]byte(`[]`))
+	require.ErrorAs(t, err, &schemaErr)
+	assert.Equal(t, []string{"/: must be object"}, schemaErr.Violations)
+
+	_, err = CompileSchema([]byte(`{"type":"text"}`))
+	assert.Error(t, err)
+	_, err = CompileSchema([]byte(`{"pattern":"("}`))
+	assert.Error(t, err)
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "user.json"), []byte(`{"type":"object","required":["email"]}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "schemas.yaml"), []byte(`
+schemas:
+  - prefix: users/
+    file: user.json
+  - prefix: users/admins/
+    schema:
+      type: object
+      properties:
+        level: {type: integer, maximum: 3}
+`), 0o644))
+
+	schemas, err := LoadConfig(filepath.Join(dir, "schemas.yaml"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, schemas.Validate("users/ada", []byte(`{}`)), ErrSchema)
+	assert.NoError(t, schemas.Validate("users/admins/root", []byte(`{"level":3}`)), "the longest prefix applies")
+	assert.ErrorIs(t, schemas.Validate("users/admins/root", []byte(`{"level":4}`)), ErrSchema)
+	assert.NoError(t, schemas.Validate("other/doc", []byte(`[]`)))
+
+	var none *Schemas
+	assert.NoError(t, none.Validate("users/ada", []byte(`{}`)))
+}
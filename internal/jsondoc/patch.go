@@ -0,0 +1,344 @@
+package jsondoc
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// operation is one step of a JSON Patch
+type operation struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Patch applies a JSON Patch to a document. Either every operation applies
+// or the document is left as it was.
+func Patch(doc, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	var ops []operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: a JSON Patch is an array of operations: %v", ErrInvalid, err)
+	}
+	for i, op := range ops {
+		if target, err = op.apply(target); err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+		}
+	}
+	return encode(target)
+}
+
+// MergePatch applies a JSON Merge Patch to a document: members of the
+// patch replace those of the document, recursively for objects, and null
+// members remove them
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decode(patch)
+	if err != nil {
+		return nil, err
+	}
+	return encode(merge(target, p))
+}
+
+func merge(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any, len(p))
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = merge(t[k], v)
+		}
+	}
+	return t
+}
+
+func (op operation) apply(doc any) (any, error) {
+	if op.Path == nil {
+		return nil, fmt.Errorf("%w: missing path", ErrPatch)
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+	value := func() (any, error) {
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: missing value", ErrPatch)
+		}
+		return decode(op.Value)
+	}
+	from := func() ([]string, error) {
+		if op.From == nil {
+			return nil, fmt.Errorf("%w: missing from", ErrPatch)
+		}
+		return parsePointer(*op.From)
+	}
+
+	switch op.Op {
+	case "add":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, v)
+	case "remove":
+		doc, _, err := remove(doc, path)
+		return doc, err
+	case "replace":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return v, nil
+		}
+		if doc, _, err = remove(doc, path); err != nil {
+			return nil, err
+		}
+		return add(doc, path, v)
+	case "move":
+		src, err := from()
+		if err != nil {
+			return nil, err
+		}
+		if len(src) < len(path) && isPrefix(src, path) {
+			return nil, fmt.Errorf("%w: cannot move a value into itself", ErrPatch)
+		}
+		doc, v, err := remove(doc, src)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, v)
+	case "copy":
+		src, err := from()
+		if err != nil {
+			return nil, err
+		}
+		v, err := get(doc, src)
+		if err != nil {
+			return nil, err
+		}
+		// The copy must not share maps or slices with the original
+		data, err := encode(v)
+		if err != nil {
+			return nil, err
+		}
+		if v, err = decode(data); err != nil {
+			return nil, err
+		}
+		return add(doc, path, v)
+	case "test":
+		want, err := value()
+		if err != nil {
+			return nil, err
+		}
+		got, err := get(doc, path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrTestFailed, err)
+		}
+		if !equal(got, want) {
+			return nil, fmt.Errorf("%w: %s does not hold the value", ErrTestFailed, *op.Path)
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("%w: unknown operation %q", ErrPatch, op.Op)
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("%w: pointer %q does not start with /", ErrPatch, pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// index parses an array index below n, or up to n when end allows the
+// position after the last element
+func index(token string, n int, end bool) (int, error) {
+	if end && token == "-" {
+		return n, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrPatch, token)
+	}
+	if i > n || (i == n && !end) {
+		return 0, fmt.Errorf("%w: array index %d out of range", ErrPatch, i)
+	}
+	return i, nil
+}
+
+// get returns the value path points to
+func get(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch n := doc.(type) {
+		case map[string]any:
+			v, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: no member %q", ErrPatch, token)
+			}
+			doc = v
+		case []any:
+			i, err := index(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = n[i]
+		default:
+			return nil, fmt.Errorf("%w: %q is below a scalar", ErrPatch, token)
+		}
+	}
+	return doc, nil
+}
+
+// edit replaces the container holding the last token of path with what
+// change makes of it, and returns the document
+func edit(doc any, path []string, change func(container any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return change(doc, path[0])
+	}
+	switch n := doc.(type) {
+	case map[string]any:
+		child, ok := n[path[0]]
+		if !ok {
+			return nil, fmt.Errorf("%w: no member %q", ErrPatch, path[0])
+		}
+		child, err := edit(child, path[1:], change)
+		if err != nil {
+			return nil, err
+		}
+		n[path[0]] = child
+		return n, nil
+	case []any:
+		i, err := index(path[0], len(n), false)
+		if err != nil {
+			return nil, err
+		}
+		if n[i], err = edit(n[i], path[1:], change); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("%w: %q is below a scalar", ErrPatch, path[0])
+}
+
+// add sets the member, or inserts the array element, path points to
+func add(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return edit(doc, path, func(container any, token string) (any, error) {
+		switch n := container.(type) {
+		case map[string]any:
+			n[token] = value
+			return n, nil
+		case []any:
+			i, err := index(token, len(n), true)
+			if err != nil {
+				return nil, err
+			}
+			return append(n[:i], append([]any{value}, n[i:]...)...), nil
+		}
+		return nil, fmt.Errorf("%w: %q is below a scalar", ErrPatch, token)
+	})
+}
+
+// remove deletes the value path points to and returns it
+func remove(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("%w: cannot remove the whole document", ErrPatch)
+	}
+	var removed any
+	doc, err := edit(doc, path, func(container any, token string) (any, error) {
+		switch n := container.(type) {
+		case map[string]any:
+			v, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: no member %q", ErrPatch, token)
+			}
+			removed = v
+			delete(n, token)
+			return n, nil
+		case []any:
+			i, err := index(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = n[i]
+			return append(n[:i], n[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("%w: %q is below a scalar", ErrPatch, token)
+	})
+	return doc, removed, err
+}
+
+// equal compares JSON values, numbers by value
+func equal(a, b any) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, okx := new(big.Float).SetString(x.String())
+		fy, oky := new(big.Float).SetString(y.String())
+		return okx && oky && fx.Cmp(fy) == 0
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, ok := y[k]
+			if !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,441 @@
+package jsondoc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrSchema is returned for documents that do not match their schema
+var ErrSchema = errors.New("jsondoc: document does not match its schema")
+
+// SchemaError lists where a document does not match its schema
+type SchemaError struct {
+	// Violations are JSON Pointers to values with what is wrong with them
+	Violations []string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrSchema, strings.Join(e.Violations, "; "))
+}
+
+func (e *SchemaError) Unwrap() error { return ErrSchema }
+
+// Schema is a compiled JSON Schema. It checks the keywords type, enum,
+// const, properties, required, additionalProperties, items, minItems,
+// maxItems, uniqueItems, minLength, maxLength, pattern, minimum, maximum,
+// exclusiveMinimum and exclusiveMaximum; other keywords are ignored.
+type Schema struct {
+	types       []string
+	enum        []any
+	constant    any
+	hasConst    bool
+	properties  map[string]*Schema
+	required    []string
+	additional  *Schema
+	closed      bool
+	items       *Schema
+	uniqueItems bool
+	pattern     *regexp.Regexp
+
+	minItems, maxItems, minLength, maxLength *int
+
+	minimum, maximum, exclusiveMinimum, exclusiveMaximum *float64
+}
+
+// CompileSchema compiles a JSON Schema
+func CompileSchema(data []byte) (*Schema, error) {
+	v, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return compile(v, "")
+}
+
+func compile(v any, at string) (*Schema, error) {
+	switch v := v.(type) {
+	case bool:
+		// true accepts everything and false nothing
+		if v {
+			return &Schema{}, nil
+		}
+		return &Schema{enum: []any{}}, nil
+	case map[string]any:
+		return compileObject(v, at)
+	}
+	return nil, fmt.Errorf("jsondoc: schema%s is not an object", at)
+}
+
+func compileObject(m map[string]any, at string) (*Schema, error) {
+	s := &Schema{}
+	fail := func(keyword, want string) error {
+		return fmt.Errorf("jsondoc: schema%s: %s must be %s", at, keyword, want)
+	}
+
+	switch t := m["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []any:
+		for _, item := range t {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fail("type", "a string or an array of strings")
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, fail("type", "a string or an array of strings")
+	}
+	for _, t := range s.types {
+		if !slices.Contains([]string{"object", "array", "string", "number", "integer", "boolean", "null"}, t) {
+			return nil, fail("type", "a JSON type")
+		}
+	}
+
+	if e, ok := m["enum"]; ok {
+		if s.enum, ok = e.([]any); !ok {
+			return nil, fail("enum", "an array")
+		}
+	}
+	s.constant, s.hasConst = m["const"]
+
+	if props, ok := m["properties"]; ok {
+		props, ok := props.(map[string]any)
+		if !ok {
+			return nil, fail("properties", "an object")
+		}
+		s.properties = make(map[string]*Schema, len(props))
+		for name, p := range props {
+			sub, err := compile(p, at+"/properties/"+name)
+			if err != nil {
+				return nil, err
+			}
+			s.properties[name] = sub
+		}
+	}
+	if req, ok := m["required"]; ok {
+		req, ok := req.([]any)
+		if !ok {
+			return nil, fail("required", "an array of strings")
+		}
+		for _, item := range req {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fail("required", "an array of strings")
+			}
+			s.required = append(s.required, name)
+		}
+	}
+	switch a := m["additionalProperties"].(type) {
+	case nil:
+	case bool:
+		s.closed = !a
+	default:
+		sub, err := compile(a, at+"/additionalProperties")
+		if err != nil {
+			return nil, err
+		}
+		s.additional = sub
+	}
+	if items, ok := m["items"]; ok {
+		sub, err := compile(items, at+"/items")
+		if err != nil {
+			return nil, err
+		}
+		s.items = sub
+	}
+	if u, ok := m["uniqueItems"]; ok {
+		if s.uniqueItems, ok = u.(bool); !ok {
+			return nil, fail("uniqueItems", "a boolean")
+		}
+	}
+	if p, ok := m["pattern"]; ok {
+		expr, ok := p.(string)
+		if !ok {
+			return nil, fail("pattern", "a string")
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fail("pattern", "a regular expression")
+		}
+		s.pattern = re
+	}
+
+	for keyword, dst := range map[string]**int{
+		"minItems": &s.minItems, "maxItems": &s.maxItems,
+		"minLength": &s.minLength, "maxLength": &s.maxLength,
+	} {
+		if v, ok := m[keyword]; ok {
+			n, ok := v.(json.Number)
+			i, err := strconv.Atoi(string(n))
+			if !ok || err != nil || i < 0 {
+				return nil, fail(keyword, "a non-negative integer")
+			}
+			*dst = &i
+		}
+	}
+	for keyword, dst := range map[string]**float64{
+		"minimum": &s.minimum, "maximum": &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum, "exclusiveMaximum": &s.exclusiveMaximum,
+	} {
+		if v, ok := m[keyword]; ok {
+			n, ok := v.(json.Number)
+			f, err := n.Float64()
+			if !ok || err != nil {
+				return nil, fail(keyword, "a number")
+			}
+			*dst = &f
+		}
+	}
+	return s, nil
+}
+
+// Validate checks a document against the schema
+func (s *Schema) Validate(doc []byte) error {
+	v, err := decode(doc)
+	if err != nil {
+		return err
+	}
+	var violations []string
+	s.check(v, "", &violations)
+	if len(violations) > 0 {
+		return &SchemaError{Violations: violations}
+	}
+	return nil
+}
+
+func (s *Schema) check(v any, at string, violations *[]string) {
+	report := func(format string, args ...any) {
+		where := at
+		if where == "" {
+			where = "/"
+		}
+		*violations = append(*violations, where+": "+fmt.Sprintf(format, args...))
+	}
+
+	if len(s.types) > 0 && !slices.ContainsFunc(s.types, func(t string) bool { return isType(v, t) }) {
+		report("must be %s", strings.Join(s.types, " or "))
+		return
+	}
+	if s.enum != nil && !slices.ContainsFunc(s.enum, func(e any) bool { return equal(v, e) }) {
+		report("must be one of the enumerated values")
+	}
+	if s.hasConst && !equal(v, s.constant) {
+		report("must be the constant value")
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				report("missing required member %q", name)
+			}
+		}
+		for _, name := range sortedKeys(v) {
+			where := at + "/" + escape(name)
+			if sub, ok := s.properties[name]; ok {
+				sub.check(v[name], where, violations)
+			} else if s.additional != nil {
+				s.additional.check(v[name], where, violations)
+			} else if s.closed {
+				*violations = append(*violations, where+": is not allowed")
+			}
+		}
+	case []any:
+		if s.minItems != nil && len(v) < *s.minItems {
+			report("must have at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			report("must have at most %d items", *s.maxItems)
+		}
+		if s.uniqueItems {
+			for i := range v {
+				if slices.ContainsFunc(v[:i], func(w any) bool { return equal(v[i], w) }) {
+					report("items must be unique")
+					break
+				}
+			}
+		}
+		if s.items != nil {
+			for i, item := range v {
+				s.items.check(item, at+"/"+strconv.Itoa(i), violations)
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			report("must be at least %d characters", *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			report("must be at most %d characters", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			report("must match %s", s.pattern)
+		}
+	case json.Number:
+		f, _ := v.Float64()
+		if s.minimum != nil && f < *s.minimum {
+			report("must be at least %v", *s.minimum)
+		}
+		if s.maximum != nil && f > *s.maximum {
+			report("must be at most %v", *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && f <= *s.exclusiveMinimum {
+			report("must be greater than %v", *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && f >= *s.exclusiveMaximum {
+			report("must be less than %v", *s.exclusiveMaximum)
+		}
+	}
+}
+
+func isType(v any, t string) bool {
+	switch v := v.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case []any:
+		return t == "array"
+	case map[string]any:
+		return t == "object"
+	case json.Number:
+		if t == "number" {
+			return true
+		}
+		f, err := v.Float64()
+		return t == "integer" && err == nil && f == math.Trunc(f)
+	}
+	return false
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// escape escapes a member name as a JSON Pointer token
+func escape(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+// Config binds schemas to the key prefixes of the documents they validate
+type Config struct {
+	Schemas []SchemaRule `yaml:"schemas" json:"schemas"`
+}
+
+// SchemaRule validates the documents below Prefix against a schema given
+// inline or in a file
+type SchemaRule struct {
+	Prefix string `yaml:"prefix" json:"prefix"`
+	// Schema is the schema itself
+	Schema map[string]any `yaml:"schema" json:"schema"`
+	// File is a JSON or YAML file holding the schema, relative to the
+	// configuration
+	File string `yaml:"file" json:"file"`
+}
+
+// Schemas finds the schema of a document by its key
+type Schemas struct {
+	rules []compiledRule
+}
+
+type compiledRule struct {
+	prefix string
+	schema *Schema
+}
+
+// LoadConfig reads the schema bindings of a YAML file and compiles them
+func LoadConfig(path string) (*Schemas, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("jsondoc: invalid config %s: %w", path, err)
+	}
+	return NewSchemas(cfg, filepath.Dir(path))
+}
+
+// NewSchemas compiles the schemas of cfg, reading schema files relative
+// to dir
+func NewSchemas(cfg Config, dir string) (*Schemas, error) {
+	s := &Schemas{}
+	for _, rule := range cfg.Schemas {
+		var source any = rule.Schema
+		if rule.File != "" {
+			if rule.Schema != nil {
+				return nil, fmt.Errorf("jsondoc: schema for %q is both inline and in a file", rule.Prefix)
+			}
+			path := rule.File
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			// YAML is a superset of JSON, so either reads
+			var m map[string]any
+			if err := yaml.Unmarshal(data, &m); err != nil {
+				return nil, fmt.Errorf("jsondoc: invalid schema %s: %w", rule.File, err)
+			}
+			source = m
+		}
+		if source == nil {
+			return nil, fmt.Errorf("jsondoc: no schema for %q", rule.Prefix)
+		}
+		// Round trip through JSON, so YAML numbers compile like JSON ones
+		data, err := json.Marshal(source)
+		if err != nil {
+			return nil, fmt.Errorf("jsondoc: schema for %q: %w", rule.Prefix, err)
+		}
+		schema, err := CompileSchema(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w (prefix %q)", err, rule.Prefix)
+		}
+		s.rules = append(s.rules, compiledRule{prefix: rule.Prefix, schema: schema})
+	}
+	// Longest prefixes first, so the most specific schema applies
+	slices.SortStableFunc(s.rules, func(a, b compiledRule) int { return len(b.prefix) - len(a.prefix) })
+	return s, nil
+}
+
+// For returns the schema of the document at key, nil when none applies
+func (s *Schemas) For(key string) *Schema {
+	if s == nil {
+		return nil
+	}
+	for _, rule := range s.rules {
+		if strings.HasPrefix(key, rule.prefix) {
+			return rule.schema
+		}
+	}
+	return nil
+}
+
+// Validate checks the document at key against its schema, if any
+func (s *Schemas) Validate(key string, doc []byte) error {
+	if schema := s.For(key); schema != nil {
+		return schema.Validate(doc)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func ago(d int) time.Time { return now.Add(-days(d)) }
+
+func TestPolicy_Validate(t *testing.T) {
+	valid := Policy{Rules: []Rule{{ID: "logs", Prefix: "logs/", ExpireAfterDays: 30, TransitionAfterDays: 7, TransitionTo: "cold"}}}
+	assert.NoError(t, valid.Validate())
+
+	cases := map[string]Policy{
+		"missing id":       {Rules: []Rule{{ExpireAfterDays: 1}}},
+		"duplicate id":     {Rules: []Rule{{ID: "a", ExpireAfterDays: 1}, {ID: "a", ExpireAfterDays: 2}}},
+		"no action":        {Rules: []Rule{{ID: "a", Prefix: "x"}}},
+		"negative":         {Rules: []Rule{{ID: "a", ExpireAfterDays: -1}}},
+		"no tier":          {Rules: []Rule{{ID: "a", TransitionAfterDays: 3}}},
+		"expire too early": {Rules: []Rule{{ID: "a", ExpireAfterDays: 3, TransitionAfterDays: 3, TransitionTo: "cold"}}},
+	}
+	for name, p := range cases {
+		assert.Error(t, p.Validate(), name)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	policy := Policy{Rules: []Rule{
+		{ID: "logs-cold", Prefix: "logs/", TransitionAfterDays: 7, TransitionTo: "cold"},
+		{ID: "logs-expire", Prefix: "logs/", ExpireAfterDays: 30},
+		{ID: "acme-expire", Tenant: "acme", ExpireAfterDays: 10},
+		{ID: "versions", NoncurrentExpireAfterDays: 5},
+		{ID: "off", Disabled: true, ExpireAfterDays: 1},
+	}}
+	objects := []Object{
+		{Key: "logs/new", CreatedAt: ago(1), Size: 1},
+		{Key: "logs/week", CreatedAt: ago(8), Size: 2},
+		{Key: "logs/cold", CreatedAt: ago(8), Size: 3, StorageClass: "cold"},
+		{Key: "logs/old", CreatedAt: ago(31), Size: 4, Versions: []Version{{ID: "v1", Size: 10, NoncurrentSince: ago(20)}}},
+		{Key: "reports/q1", Tenant: "acme", CreatedAt: ago(11), Size: 5},
+		{Key: "reports/q2", Tenant: "other", CreatedAt: ago(11), Size: 6, Versions: []Version{
+			{ID: "v2", Size: 7, NoncurrentSince: ago(6)},
+			{ID: "v1", Size: 8, NoncurrentSince: ago(2)},
+		}},
+	}
+
+	actions := Evaluate(policy, objects, now)
+	require.Len(t, actions, 4)
+
+	assert.Equal(t, Action{Type: ActionExpire, RuleID: "logs-expire", Key: "logs/old", Size: 14, AgeDays: 31}, actions[0])
+	assert.Equal(t, Action{Type: ActionTransition, RuleID: "logs-cold", Key: "logs/week", StorageClass: "cold", Size: 2, AgeDays: 8}, actions[1])
+	assert.Equal(t, ActionExpire, actions[2].Type)
+	assert.Equal(t, "reports/q1", actions[2].Key)
+	assert.Equal(t, "acme-expire", actions[2].RuleID)
+	assert.Equal(t, Action{Type: ActionDeleteVersion, RuleID: "versions", Key: "reports/q2", Tenant: "other", VersionID: "v2", Size: 7, AgeDays: 6}, actions[3])
+}
+
+func TestEvaluate_SoonestRuleWins(t *testing.T) {
+	policy := Policy{Rules: []Rule{
+		{ID: "slow", ExpireAfterDays: 20},
+		{ID: "fast", Prefix: "tmp/", ExpireAfterDays: 2},
+	}}
+	actions := Evaluate(policy, []Object{{Key: "tmp/a", CreatedAt: ago(30)}}, now)
+	require.Len(t, actions, 1)
+	assert.Equal(t, "fast", actions[0].RuleID)
+}
+
+func newTestScheduler(t *testing.T, path string) (*Scheduler, *metadata.Store) {
+	t.Helper()
+	// Versions become noncurrent at wall-clock time, so the records are
+	// dated from it and the scheduler looks at them from 35 days later
+	wall := time.Now()
+	store := metadata.NewStore(metadata.StoreOpts{})
+	_, err := store.Put(metadata.FileRecord{Key: "logs/a", Size: 10, Hash: "a1", Owner: "acme", CreatedAt: wall.Add(-days(40))})
+	require.NoError(t, err)
+	_, err = store.Put(metadata.FileRecord{Key: "logs/b", Size: 20, Hash: "b1", CreatedAt: wall.Add(-days(10))})
+	require.NoError(t, err)
+	_, err = store.Put(metadata.FileRecord{Key: "docs/c", Size: 30, Hash: "c1", CreatedAt: wall.Add(-days(1))})
+	require.NoError(t, err)
+	_, err = store.Put(metadata.FileRecord{Key: "docs/c", Size: 31, Hash: "c2", CreatedAt: wall.Add(-days(1))})
+	require.NoError(t, err)
+
+	s, err := NewScheduler(NewMetadataTarget(store, nil), SchedulerOpts{PolicyPath: path})
+	require.NoError(t, err)
+	s.now = func() time.Time { return wall.Add(days(35)) }
+	return s, store
+}
+
+func TestScheduler_DryRunThenEnforce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lifecycle.json")
+	s, store := newTestScheduler(t, path)
+	ctx := context.Background()
+
+	require.NoError(t, s.SetPolicy(Policy{Rules: []Rule{
+		{ID: "logs", Prefix: "logs/", TransitionAfterDays: 5, TransitionTo: metadata.StorageClassCold, ExpireAfterDays: 90},
+		{ID: "acme", Tenant: "acme", ExpireAfterDays: 30},
+		{ID: "versions", NoncurrentExpireAfterDays: 30},
+	}}))
+
+	report, err := s.Run(ctx, true)
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 3, report.Objects)
+	assert.Equal(t, Summary{Expired: 1, Transitioned: 1, VersionsDeleted: 1, BytesExpired: 10, BytesTransitioned: 20, BytesVersionsDeleted: 30}, report.Summary)
+	for _, a := range report.Actions {
+		assert.Equal(t, StatusPlanned, a.Status)
+	}
+	assert.Len(t, store.List(), 3, "a dry run changes nothing")
+	assert.Same(t, report, s.LastReport())
+
+	report, err = s.Run(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Summary.Failed)
+
+	_, err = store.Get("logs/a")
+	assert.ErrorIs(t, err, metadata.ErrNotFound)
+	rec, err := store.Get("logs/b")
+	require.NoError(t, err)
+	assert.Equal(t, metadata.StorageClassCold, rec.Class())
+	rec, err = store.Get("docs/c")
+	require.NoError(t, err)
+	assert.Empty(t, rec.Versions)
+
+	// Nothing is left to do, and the policy survives a restart
+	report, err = s.Run(ctx, true)
+	require.NoError(t, err)
+	assert.Empty(t, report.Actions)
+
+	reloaded, err := NewScheduler(NewMetadataTarget(store, nil), SchedulerOpts{PolicyPath: path})
+	require.NoError(t, err)
+	assert.Len(t, reloaded.Policy().Rules, 3)
+	assert.Error(t, reloaded.SetPolicy(Policy{Rules: []Rule{{ID: "bad"}}}))
+	assert.Len(t, reloaded.Policy().Rules, 3)
+}
+
+func TestScheduler_RecordsFailures(t *testing.T) {
+	store := metadata.NewStore(metadata.StoreOpts{})
+	_, err := store.Put(metadata.FileRecord{Key: "a", Size: 1, CreatedAt: time.Now().Add(-days(400))})
+	require.NoError(t, err)
+
+	failing := NewMetadataTarget(store, func(ctx context.Context, key string) error {
+		return errors.New("disk on fire")
+	})
+	s, err := NewScheduler(failing, SchedulerOpts{})
+	require.NoError(t, err)
+	require.NoError(t, s.SetPolicy(Policy{Rules: []Rule{{ID: "all", ExpireAfterDays: 1}}}))
+
+	report, err := s.Run(context.Background(), false)
+	require.NoError(t, err)
+	require.Len(t, report.Actions, 1)
+	assert.Equal(t, StatusFailed, report.Actions[0].Status)
+	assert.Equal(t, "disk on fire", report.Actions[0].Error)
+	assert.Equal(t, 1, report.Summary.Failed)
+}
+
+func TestScheduler_StartStop(t *testing.T) {
+	store := metadata.NewStore(metadata.StoreOpts{})
+	_, err := store.Put(metadata.FileRecord{Key: "a", Size: 1, CreatedAt: time.Now().Add(-days(400))})
+	require.NoError(t, err)
+
+	s, err := NewScheduler(NewMetadataTarget(store, nil), SchedulerOpts{Interval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	require.NoError(t, s.SetPolicy(Policy{Rules: []Rule{{ID: "all", ExpireAfterDays: 1}}}))
+
+	s.Start()
+	require.Eventually(t, func() bool { return s.LastReport() != nil }, time.Second, 5*time.Millisecond)
+	s.Stop()
+
+	// Not enforcing, so scheduled runs only report
+	assert.True(t, s.LastReport().DryRun)
+	assert.Len(t, store.List(), 1)
+}
@@ -0,0 +1,58 @@
+package lifecycle
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/internal/metadata"
+)
+
+// MetadataTarget applies lifecycle actions to a metadata store. Tenants are
+// the record owners.
+type MetadataTarget struct {
+	store *metadata.Store
+	// expire deletes an object everywhere it lives; defaults to removing
+	// the metadata record
+	expire func(ctx context.Context, key string) error
+}
+
+// NewMetadataTarget creates a target over store. expire, when set, is used
+// to delete expired objects so their content is removed along with the record.
+func NewMetadataTarget(store *metadata.Store, expire func(ctx context.Context, key string) error) *MetadataTarget {
+	return &MetadataTarget{store: store, expire: expire}
+}
+
+func (t *MetadataTarget) Objects(ctx context.Context) ([]Object, error) {
+	records := t.store.List()
+	objects := make([]Object, len(records))
+	for i, rec := range records {
+		objects[i] = Object{
+			Key:          rec.Key,
+			Tenant:       rec.Owner,
+			Size:         rec.Size,
+			CreatedAt:    rec.CreatedAt,
+			StorageClass: rec.Class(),
+		}
+		for _, v := range rec.Versions {
+			objects[i].Versions = append(objects[i].Versions, Version{ID: v.ID, Size: v.Size, NoncurrentSince: v.NoncurrentSince})
+		}
+	}
+	return objects, nil
+}
+
+func (t *MetadataTarget) Expire(ctx context.Context, key string) error {
+	if t.expire != nil {
+		return t.expire(ctx, key)
+	}
+	_, err := t.store.Delete(key)
+	return err
+}
+
+func (t *MetadataTarget) Transition(ctx context.Context, key, storageClass string) error {
+	_, err := t.store.SetStorageClass(key, storageClass)
+	return err
+}
+
+func (t *MetadataTarget) DeleteVersion(ctx context.Context, key, versionID string) error {
+	_, err := t.store.DeleteVersion(key, versionID)
+	return err
+}
@@ -0,0 +1,211 @@
+package lifecycle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Day is the unit lifecycle ages are expressed in
+const Day = 24 * time.Hour
+
+// ActionType is what a rule does to an object
+type ActionType string
+
+const (
+	ActionExpire        ActionType = "expire"
+	ActionTransition    ActionType = "transition"
+	ActionDeleteVersion ActionType = "delete_version"
+)
+
+// Rule applies lifecycle actions to the objects it selects. A rule selects
+// objects by key prefix and tenant; empty selectors match everything.
+type Rule struct {
+	ID       string `json:"id"`
+	Disabled bool   `json:"disabled,omitempty"`
+	Prefix   string `json:"prefix,omitempty"`
+	Tenant   string `json:"tenant,omitempty"`
+
+	// ExpireAfterDays deletes objects this many days after creation
+	ExpireAfterDays int `json:"expire_after_days,omitempty"`
+	// TransitionAfterDays moves objects to TransitionTo after this many days
+	TransitionAfterDays int    `json:"transition_after_days,omitempty"`
+	TransitionTo        string `json:"transition_to,omitempty"`
+	// NoncurrentExpireAfterDays deletes versions this many days after they
+	// were replaced
+	NoncurrentExpireAfterDays int `json:"noncurrent_expire_after_days,omitempty"`
+}
+
+// Matches reports whether the rule selects an object
+func (r *Rule) Matches(obj *Object) bool {
+	return !r.Disabled &&
+		strings.HasPrefix(obj.Key, r.Prefix) &&
+		(r.Tenant == "" || r.Tenant == obj.Tenant)
+}
+
+// Policy is an ordered set of lifecycle rules
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Validate checks a policy for mistakes that would make it misbehave
+func (p *Policy) Validate() error {
+	seen := make(map[string]struct{}, len(p.Rules))
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.ID == "" {
+			return fmt.Errorf("lifecycle: rule %d has no id", i)
+		}
+		if _, dup := seen[r.ID]; dup {
+			return fmt.Errorf("lifecycle: duplicate rule id %q", r.ID)
+		}
+		seen[r.ID] = struct{}{}
+
+		if r.ExpireAfterDays < 0 || r.TransitionAfterDays < 0 || r.NoncurrentExpireAfterDays < 0 {
+			return fmt.Errorf("lifecycle: rule %q has a negative age", r.ID)
+		}
+		if r.ExpireAfterDays == 0 && r.TransitionAfterDays == 0 && r.NoncurrentExpireAfterDays == 0 {
+			return fmt.Errorf("lifecycle: rule %q has no action", r.ID)
+		}
+		if r.TransitionAfterDays > 0 && r.TransitionTo == "" {
+			return fmt.Errorf("lifecycle: rule %q transitions without transition_to", r.ID)
+		}
+		if r.TransitionAfterDays > 0 && r.ExpireAfterDays > 0 && r.TransitionAfterDays >= r.ExpireAfterDays {
+			return fmt.Errorf("lifecycle: rule %q expires objects before they transition", r.ID)
+		}
+	}
+	return nil
+}
+
+// LoadPolicy reads a policy from a JSON file; a missing file is an empty policy
+func LoadPolicy(path string) (Policy, error) {
+	var p Policy
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("lifecycle: invalid policy %s: %w", path, err)
+	}
+	return p, p.Validate()
+}
+
+// SavePolicy writes a policy to a JSON file
+func SavePolicy(path string, p Policy) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Object is a stored file as seen by lifecycle evaluation
+type Object struct {
+	Key          string
+	Tenant       string
+	Size         int64
+	CreatedAt    time.Time
+	StorageClass string
+	Versions     []Version
+}
+
+func (o *Object) versionBytes() int64 {
+	var n int64
+	for _, v := range o.Versions {
+		n += v.Size
+	}
+	return n
+}
+
+// Version is a noncurrent version of an object
+type Version struct {
+	ID              string
+	Size            int64
+	NoncurrentSince time.Time
+}
+
+// Action is a single lifecycle change due for an object
+type Action struct {
+	Type         ActionType `json:"type"`
+	RuleID       string     `json:"rule_id"`
+	Key          string     `json:"key"`
+	Tenant       string     `json:"tenant,omitempty"`
+	VersionID    string     `json:"version_id,omitempty"`
+	StorageClass string     `json:"storage_class,omitempty"`
+	Size         int64      `json:"size"`
+	AgeDays      int        `json:"age_days"`
+}
+
+// Evaluate returns the actions a policy calls for at the given time. When
+// several rules match, the one that acts soonest wins, and an expiry
+// supersedes a transition of the same object.
+func Evaluate(p Policy, objects []Object, now time.Time) []Action {
+	var actions []Action
+	for i := range objects {
+		obj := &objects[i]
+		age := now.Sub(obj.CreatedAt)
+
+		var expire, transition *Rule
+		noncurrent := make(map[string]*Rule)
+		for j := range p.Rules {
+			r := &p.Rules[j]
+			if !r.Matches(obj) {
+				continue
+			}
+			if r.ExpireAfterDays > 0 && age >= days(r.ExpireAfterDays) &&
+				(expire == nil || r.ExpireAfterDays < expire.ExpireAfterDays) {
+				expire = r
+			}
+			if r.TransitionAfterDays > 0 && r.TransitionTo != obj.StorageClass && age >= days(r.TransitionAfterDays) &&
+				(transition == nil || r.TransitionAfterDays < transition.TransitionAfterDays) {
+				transition = r
+			}
+			if r.NoncurrentExpireAfterDays > 0 {
+				for _, v := range obj.Versions {
+					best := noncurrent[v.ID]
+					if now.Sub(v.NoncurrentSince) >= days(r.NoncurrentExpireAfterDays) &&
+						(best == nil || r.NoncurrentExpireAfterDays < best.NoncurrentExpireAfterDays) {
+						noncurrent[v.ID] = r
+					}
+				}
+			}
+		}
+
+		ageDays := int(age / Day)
+		if expire != nil {
+			// Expiring an object removes its history with it
+			actions = append(actions, Action{Type: ActionExpire, RuleID: expire.ID, Key: obj.Key, Tenant: obj.Tenant, Size: obj.Size + obj.versionBytes(), AgeDays: ageDays})
+			continue
+		}
+		if transition != nil {
+			actions = append(actions, Action{Type: ActionTransition, RuleID: transition.ID, Key: obj.Key, Tenant: obj.Tenant, StorageClass: transition.TransitionTo, Size: obj.Size, AgeDays: ageDays})
+		}
+		for _, v := range obj.Versions {
+			if r, ok := noncurrent[v.ID]; ok {
+				actions = append(actions, Action{Type: ActionDeleteVersion, RuleID: r.ID, Key: obj.Key, Tenant: obj.Tenant, VersionID: v.ID, Size: v.Size, AgeDays: int(now.Sub(v.NoncurrentSince) / Day)})
+			}
+		}
+	}
+
+	sort.SliceStable(actions, func(i, j int) bool { return actions[i].Key < actions[j].Key })
+	return actions
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * Day
+}
@@ -0,0 +1,280 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/scheduler"
+)
+
+// ErrRunInProgress is returned when an evaluation is already running
+var ErrRunInProgress = errors.New("lifecycle: a run is already in progress")
+
+// Target is the object store lifecycle rules are applied to
+type Target interface {
+	// Objects lists every object with its versions
+	Objects(ctx context.Context) ([]Object, error)
+	// Expire deletes an object and its history
+	Expire(ctx context.Context, key string) error
+	// Transition moves the current content of an object to another tier
+	Transition(ctx context.Context, key, storageClass string) error
+	// DeleteVersion deletes a noncurrent version
+	DeleteVersion(ctx context.Context, key, versionID string) error
+}
+
+// ActionStatus is the outcome of an action in a run
+type ActionStatus string
+
+const (
+	StatusPlanned ActionStatus = "planned"
+	StatusApplied ActionStatus = "applied"
+	StatusFailed  ActionStatus = "failed"
+)
+
+// ActionResult is an action together with what happened to it
+type ActionResult struct {
+	Action
+	Status ActionStatus `json:"status"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// Summary totals a run per action type
+type Summary struct {
+	Expired              int   `json:"expired"`
+	Transitioned         int   `json:"transitioned"`
+	VersionsDeleted      int   `json:"versions_deleted"`
+	Failed               int   `json:"failed"`
+	BytesExpired         int64 `json:"bytes_expired"`
+	BytesTransitioned    int64 `json:"bytes_transitioned"`
+	BytesVersionsDeleted int64 `json:"bytes_versions_deleted"`
+}
+
+// Report describes a lifecycle run. In a dry run every action stays planned.
+type Report struct {
+	DryRun     bool           `json:"dry_run"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+	Objects    int            `json:"objects"`
+	Rules      int            `json:"rules"`
+	Actions    []ActionResult `json:"actions"`
+	Summary    Summary        `json:"summary"`
+}
+
+func (r *Report) count(res ActionResult) {
+	if res.Status == StatusFailed {
+		r.Summary.Failed++
+		return
+	}
+	switch res.Type {
+	case ActionExpire:
+		r.Summary.Expired++
+		r.Summary.BytesExpired += res.Size
+	case ActionTransition:
+		r.Summary.Transitioned++
+		r.Summary.BytesTransitioned += res.Size
+	case ActionDeleteVersion:
+		r.Summary.VersionsDeleted++
+		r.Summary.BytesVersionsDeleted += res.Size
+	}
+}
+
+// SchedulerOpts configures a Scheduler
+type SchedulerOpts struct {
+	// Interval between scheduled runs; defaults to one hour
+	Interval time.Duration
+	// Enforce applies actions on scheduled runs. When false scheduled runs
+	// only report what they would do, so a policy can be reviewed first.
+	Enforce bool
+	// PolicyPath persists the policy; empty keeps it in memory
+	PolicyPath string
+	Logger     *slog.Logger
+}
+
+// Scheduler evaluates the lifecycle policy against a target periodically
+type Scheduler struct {
+	target Target
+	opts   SchedulerOpts
+
+	mu         sync.Mutex
+	policy     Policy
+	lastReport *Report
+
+	running sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	now     func() time.Time
+}
+
+// NewScheduler creates a scheduler, loading the policy from opts.PolicyPath
+func NewScheduler(target Target, opts SchedulerOpts) (*Scheduler, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Hour
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	s := &Scheduler{target: target, opts: opts, now: time.Now}
+	if opts.PolicyPath != "" {
+		policy, err := LoadPolicy(opts.PolicyPath)
+		if err != nil {
+			return nil, err
+		}
+		s.policy = policy
+	}
+	return s, nil
+}
+
+// Enforcing reports whether scheduled runs apply actions
+func (s *Scheduler) Enforcing() bool {
+	return s.opts.Enforce
+}
+
+// Policy returns the current policy
+func (s *Scheduler) Policy() Policy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Policy{Rules: append([]Rule(nil), s.policy.Rules...)}
+}
+
+// SetPolicy validates, persists and activates a policy
+func (s *Scheduler) SetPolicy(p Policy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if s.opts.PolicyPath != "" {
+		if err := SavePolicy(s.opts.PolicyPath, p); err != nil {
+			return fmt.Errorf("lifecycle: failed to save policy: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	s.policy = Policy{Rules: append([]Rule(nil), p.Rules...)}
+	s.mu.Unlock()
+	return nil
+}
+
+// LastReport returns the report of the most recent run, or nil
+func (s *Scheduler) LastReport() *Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastReport
+}
+
+// Start runs the policy every Interval until Stop is called
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.Run(ctx, !s.opts.Enforce); err != nil && !errors.Is(err, ErrRunInProgress) && ctx.Err() == nil {
+					s.opts.Logger.Error("Lifecycle run failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Register puts the scheduled runs on a node scheduler as the "lifecycle"
+// job, which runs them instead of Start
+func (s *Scheduler) Register(sched *scheduler.Scheduler) error {
+	return sched.Register(scheduler.Job{
+		Name:        "lifecycle",
+		Description: "Evaluate the lifecycle policy",
+		Schedule:    "@every " + max(s.opts.Interval, time.Minute).String(),
+		Run: func(ctx context.Context) error {
+			_, err := s.Run(ctx, !s.opts.Enforce)
+			return err
+		},
+	})
+}
+
+// Unregister takes the scheduled runs off a node scheduler
+func (s *Scheduler) Unregister(sched *scheduler.Scheduler) {
+	sched.Unregister("lifecycle")
+}
+
+// Stop ends scheduled runs, cancelling one in progress
+func (s *Scheduler) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+	s.cancel = nil
+}
+
+// Run evaluates the policy once. A dry run only reports the actions that
+// are due; otherwise they are applied and failures recorded per action.
+func (s *Scheduler) Run(ctx context.Context, dryRun bool) (*Report, error) {
+	if !s.running.TryLock() {
+		return nil, ErrRunInProgress
+	}
+	defer s.running.Unlock()
+
+	policy := s.Policy()
+	report := &Report{DryRun: dryRun, StartedAt: s.now(), Rules: len(policy.Rules), Actions: []ActionResult{}}
+
+	objects, err := s.target.Objects(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("lifecycle: failed to list objects: %w", err)
+	}
+	report.Objects = len(objects)
+
+	for _, action := range Evaluate(policy, objects, report.StartedAt) {
+		res := ActionResult{Action: action, Status: StatusPlanned}
+		if !dryRun {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if err := s.apply(ctx, action); err != nil {
+				res.Status = StatusFailed
+				res.Error = err.Error()
+			} else {
+				res.Status = StatusApplied
+			}
+		}
+		report.count(res)
+		report.Actions = append(report.Actions, res)
+	}
+	report.FinishedAt = s.now()
+
+	s.opts.Logger.Info("Lifecycle run finished",
+		"dry_run", dryRun,
+		"objects", report.Objects,
+		"expired", report.Summary.Expired,
+		"transitioned", report.Summary.Transitioned,
+		"versions_deleted", report.Summary.VersionsDeleted,
+		"failed", report.Summary.Failed,
+	)
+
+	s.mu.Lock()
+	s.lastReport = report
+	s.mu.Unlock()
+	return report, nil
+}
+
+func (s *Scheduler) apply(ctx context.Context, action Action) error {
+	switch action.Type {
+	case ActionExpire:
+		return s.target.Expire(ctx, action.Key)
+	case ActionTransition:
+		return s.target.Transition(ctx, action.Key, action.StorageClass)
+	case ActionDeleteVersion:
+		return s.target.DeleteVersion(ctx, action.Key, action.VersionID)
+	default:
+		return fmt.Errorf("lifecycle: unknown action %q", action.Type)
+	}
+}
@@ -1,12 +1,21 @@
 package logging
 
 import (
+	"io"
 	"log/slog"
 	"os"
+
+	"github.com/Skpow1234/Peervault/internal/requestid"
 )
 
 // ConfigureLogger sets up structured logging with appropriate level and format
 func ConfigureLogger(level string) {
+	ConfigureLoggerOutput(level, os.Stdout)
+}
+
+// ConfigureLoggerOutput is ConfigureLogger writing to w, e.g. a log file
+// for a service that has no console
+func ConfigureLoggerOutput(level string, w io.Writer) {
 	var logLevel slog.Level
 	switch level {
 	case "debug":
@@ -27,8 +36,9 @@ func ConfigureLogger(level string) {
 		AddSource: true,
 	}
 
-	// Use JSON handler for structured logging
-	handler := slog.NewJSONHandler(os.Stdout, opts)
+	// Use JSON handler for structured logging; records logged with a
+	// request's context carry its request ID
+	handler := requestid.NewLogHandler(slog.NewJSONHandler(w, opts))
 	logger := slog.New(handler)
 	slog.SetDefault(logger)
 }
@@ -0,0 +1,383 @@
+package lwm2m
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CoAP content formats of LwM2M payloads
+const (
+	FormatText       = 0
+	FormatLinkFormat = 40
+	FormatOpaque     = 42
+	FormatSenMLJSON  = 110
+	FormatTLV        = 11542
+	FormatJSON       = 11543
+)
+
+// Value is the value of a resource or resource instance. Values are
+// strings, int64, float64, bools, []byte for opaque resources, and
+// "object:instance" strings for object links.
+type Value struct {
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// decode decodes the payload a device answered a read of path with
+func decode(path Path, format int, payload []byte) ([]Value, error) {
+	switch format {
+	case FormatText, -1:
+		v, err := decodeText(path, string(payload))
+		if err != nil {
+			return nil, err
+		}
+		return []Value{{Path: path.String(), Value: v}}, nil
+	case FormatOpaque:
+		return []Value{{Path: path.String(), Value: payload}}, nil
+	case FormatTLV:
+		return decodeTLV(path, payload)
+	case FormatSenMLJSON:
+		return decodeSenML(payload)
+	case FormatJSON:
+		return decodeJSON(path, payload)
+	default:
+		return nil, fmt.Errorf("%w: unsupported content format %d", ErrDevice, format)
+	}
+}
+
+// decodeText decodes a plain text value by the type of its resource
+func decodeText(path Path, s string) (any, error) {
+	def, _ := path.resource()
+	var (
+		v   any
+		err error
+	)
+	switch def.Type {
+	case Integer, Unsigned, Time:
+		v, err = strconv.ParseInt(s, 10, 64)
+	case Float:
+		v, err = strconv.ParseFloat(s, 64)
+	case Boolean:
+		v, err = strconv.ParseBool(s)
+	default:
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s %q at %s", ErrDevice, def.Type, s, path)
+	}
+	return v, nil
+}
+
+// TLV identifier types
+const (
+	tlvObjectInstance   = 0
+	tlvResourceInstance = 1
+	tlvMultipleResource = 2
+	tlvResource         = 3
+)
+
+// tlv is an entry of an OMA-TLV payload
+type tlv struct {
+	kind  byte
+	id    uint16
+	value []byte
+}
+
+// parseTLV splits an OMA-TLV payload into its entries
+func parseTLV(data []byte) ([]tlv, error) {
+	var entries []tlv
+	for len(data) > 0 {
+		typ := data[0]
+		n := 1
+		e := tlv{kind: typ >> 6}
+		if typ&0x20 != 0 {
+			if len(data) < n+2 {
+				return nil, fmt.Errorf("%w: truncated TLV", ErrDevice)
+			}
+			e.id = binary.BigEndian.Uint16(data[n:])
+			n += 2
+		} else {
+			if len(data) < n+1 {
+				return nil, fmt.Errorf("%w: truncated TLV", ErrDevice)
+			}
+			e.id = uint16(data[n])
+			n++
+		}
+		length := int(typ & 0x07)
+		if lengthBytes := int(typ>>3) & 0x03; lengthBytes > 0 {
+			if len(data) < n+lengthBytes {
+				return nil, fmt.Errorf("%w: truncated TLV", ErrDevice)
+			}
+			length = 0
+			for _, b := range data[n : n+lengthBytes] {
+				length = length<<8 | int(b)
+			}
+			n += lengthBytes
+		}
+		if len(data) < n+length {
+			return nil, fmt.Errorf("%w: truncated TLV", ErrDevice)
+		}
+		e.value = data[n : n+length]
+		entries = append(entries, e)
+		data = data[n+length:]
+	}
+	return entries, nil
+}
+
+// decodeTLV decodes an OMA-TLV payload answering a read of path
+func decodeTLV(path Path, data []byte) ([]Value, error) {
+	entries, err := parseTLV(data)
+	if err != nil {
+		return nil, err
+	}
+	// Entries are children of the object, object instance or resource
+	// their kind is within
+	parents := map[byte]int{tlvObjectInstance: 1, tlvResource: 2, tlvMultipleResource: 2, tlvResourceInstance: 3}
+	var values []Value
+	for _, e := range entries {
+		depth := parents[e.kind]
+		if len(path) < depth {
+			return nil, fmt.Errorf("%w: unexpected TLV entry under %s", ErrDevice, path)
+		}
+		child := path[:depth].child(e.id)
+		switch e.kind {
+		case tlvObjectInstance, tlvMultipleResource:
+			nested, err := decodeTLV(child, e.value)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, nested...)
+		default:
+			v, err := decodeTLVValue(child, e.value)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, Value{Path: child.String(), Value: v})
+		}
+	}
+	return values, nil
+}
+
+// decodeTLVValue decodes the binary value of a TLV entry by the type of
+// its resource
+func decodeTLVValue(path Path, b []byte) (any, error) {
+	def, ok := path.resource()
+	if !ok {
+		return textOrBytes(b), nil
+	}
+	switch def.Type {
+	case Integer, Unsigned, Time:
+		switch len(b) {
+		case 1:
+			return int64(int8(b[0])), nil
+		case 2:
+			return int64(int16(binary.BigEndian.Uint16(b))), nil
+		case 4:
+			return int64(int32(binary.BigEndian.Uint32(b))), nil
+		case 8:
+			return int64(binary.BigEndian.Uint64(b)), nil
+		}
+	case Float:
+		switch len(b) {
+		case 4:
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+		case 8:
+			return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+		}
+	case Boolean:
+		if len(b) == 1 && b[0] <= 1 {
+			return b[0] == 1, nil
+		}
+	case ObjLnk:
+		if len(b) == 4 {
+			return fmt.Sprintf("%d:%d", binary.BigEndian.Uint16(b), binary.BigEndian.Uint16(b[2:])), nil
+		}
+	case Opaque:
+		return b, nil
+	default:
+		return string(b), nil
+	}
+	return nil, fmt.Errorf("%w: invalid %s of %d bytes at %s", ErrDevice, def.Type, len(b), path)
+}
+
+// textOrBytes returns b as a string when it is printable text
+func textOrBytes(b []byte) any {
+	s := string(b)
+	if !utf8.ValidString(s) || strings.IndexFunc(s, func(r rune) bool { return !unicode.IsPrint(r) && !unicode.IsSpace(r) }) >= 0 {
+		return b
+	}
+	return s
+}
+
+// senmlRecord is a record of a SenML JSON pack
+type senmlRecord struct {
+	BaseName   string   `json:"bn,omitempty"`
+	Name       string   `json:"n,omitempty"`
+	Number     *float64 `json:"v,omitempty"`
+	String     *string  `json:"vs,omitempty"`
+	Bool       *bool    `json:"vb,omitempty"`
+	Data       *string  `json:"vd,omitempty"`
+	ObjectLink *string  `json:"vlo,omitempty"`
+	OldString  *string  `json:"sv,omitempty"`
+	OldBool    *bool    `json:"bv,omitempty"`
+	OldObjLink *string  `json:"ov,omitempty"`
+}
+
+// decodeSenML decodes a SenML JSON payload (RFC 8428), whose records name
+// their full paths
+func decodeSenML(data []byte) ([]Value, error) {
+	var records []senmlRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("%w: invalid SenML JSON: %v", ErrDevice, err)
+	}
+	return decodeRecords("", records)
+}
+
+// decodeJSON decodes the LwM2M 1.0 JSON format, whose records name paths
+// relative to bn or to the path read
+func decodeJSON(path Path, data []byte) ([]Value, error) {
+	var pack struct {
+		BaseName string        `json:"bn"`
+		Records  []senmlRecord `json:"e"`
+	}
+	if err := json.Unmarshal(data, &pack); err != nil {
+		return nil, fmt.Errorf("%w: invalid LwM2M JSON: %v", ErrDevice, err)
+	}
+	base := pack.BaseName
+	if base == "" {
+		base = path.String() + "/"
+	}
+	return decodeRecords(base, pack.Records)
+}
+
+// decodeRecords decodes SenML records, base naming the records until one
+// sets another
+func decodeRecords(base string, records []senmlRecord) ([]Value, error) {
+	values := make([]Value, 0, len(records))
+	for _, r := range records {
+		if r.BaseName != "" {
+			base = r.BaseName
+		}
+		path, err := ParsePath(base + r.Name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid record name %q", ErrDevice, base+r.Name)
+		}
+		var v any
+		switch {
+		case r.Number != nil:
+			v = *r.Number
+			if def, ok := path.resource(); ok && (def.Type == Integer || def.Type == Unsigned || def.Type == Time) {
+				v = int64(*r.Number)
+			}
+		case r.String != nil:
+			v = *r.String
+		case r.OldString != nil:
+			v = *r.OldString
+		case r.Bool != nil:
+			v = *r.Bool
+		case r.OldBool != nil:
+			v = *r.OldBool
+		case r.Data != nil:
+			b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(*r.Data, "="))
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid opaque value at %s", ErrDevice, path)
+			}
+			v = b
+		case r.ObjectLink != nil:
+			v = *r.ObjectLink
+		case r.OldObjLink != nil:
+			v = *r.OldObjLink
+		default:
+			continue
+		}
+		values = append(values, Value{Path: path.String(), Value: v})
+	}
+	return values, nil
+}
+
+// encode encodes a value written to a resource as plain text, or as
+// opaque bytes for opaque resources, whose values are base64 strings.
+// Values come from JSON, so numbers are float64.
+func encode(path Path, value any) (int, []byte, error) {
+	if len(path) < 3 {
+		return 0, nil, fmt.Errorf("%w: only resources can be written, not %s", ErrInvalid, path)
+	}
+	def, known := path.resource()
+	if known && def.Type == Opaque {
+		s, ok := value.(string)
+		if !ok {
+			return 0, nil, fmt.Errorf("%w: %s takes base64 bytes", ErrInvalid, path)
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return 0, nil, fmt.Errorf("%w: %s takes base64 bytes", ErrInvalid, path)
+		}
+		return FormatOpaque, b, nil
+	}
+	if known && (def.Type == None || !strings.Contains(def.Operations, "W")) {
+		return 0, nil, fmt.Errorf("%w: %s (%s) is not writable", ErrInvalid, path, def.Name)
+	}
+
+	var text string
+	switch v := value.(type) {
+	case string:
+		text = v
+	case bool:
+		text = "0"
+		if v {
+			text = "1"
+		}
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+		if known && (def.Type == Integer || def.Type == Unsigned || def.Type == Time) && v != math.Trunc(v) {
+			return 0, nil, fmt.Errorf("%w: %s takes an integer", ErrInvalid, path)
+		}
+	default:
+		return 0, nil, fmt.Errorf("%w: %s takes a string, number or boolean", ErrInvalid, path)
+	}
+	if known {
+		v, err := decodeText(path, text)
+		if err != nil {
+			return 0, nil, fmt.Errorf("%w: %s takes a %s", ErrInvalid, path, def.Type)
+		}
+		// Booleans are written as 0 or 1
+		if b, ok := v.(bool); ok {
+			text = map[bool]string{false: "0", true: "1"}[b]
+		}
+	}
+	return FormatText, []byte(text), nil
+}
+
+// parseLinks returns the root path and the object paths of a CoRE link
+// format registration payload, such as </>;rt="oma.lwm2m",</1/0>,</3/0>
+func parseLinks(payload string) (string, []string) {
+	var root string
+	var links []string
+	for _, link := range strings.Split(payload, ",") {
+		link = strings.TrimSpace(link)
+		start, end := strings.IndexByte(link, '<'), strings.IndexByte(link, '>')
+		if start != 0 || end < 0 {
+			continue
+		}
+		target := link[1:end]
+		if strings.Contains(link[end:], `rt="oma.lwm2m"`) {
+			root = strings.TrimRight(target, "/")
+			continue
+		}
+		links = append(links, target)
+	}
+	objects := make([]string, 0, len(links))
+	for _, target := range links {
+		if path, err := ParsePath(strings.TrimPrefix(target, root)); err == nil && len(path) <= 2 {
+			objects = append(objects, path.String())
+		}
+	}
+	return root, objects
+}
@@ -0,0 +1,367 @@
+package lwm2m
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skpow1234/Peervault/internal/devices"
+)
+
+// fakeTransport answers requests with the responses of its handler and
+// keeps the observations to notify
+type fakeTransport struct {
+	mu        sync.Mutex
+	handler   func(Request) Response
+	requests  []Request
+	observers map[string]func(Response)
+}
+
+func newFakeTransport(handler func(Request) Response) *fakeTransport {
+	return &fakeTransport{handler: handler, observers: make(map[string]func(Response))}
+}
+
+func (t *fakeTransport) Send(ctx context.Context, addr string, req Request) (Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests = append(t.requests, req)
+	return t.handler(req), nil
+}
+
+func (t *fakeTransport) Observe(ctx context.Context, addr string, req Request, notify func(Response)) (Response, func(), error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests = append(t.requests, req)
+	t.observers[req.Path] = notify
+	cancel := func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.observers, req.Path)
+	}
+	return t.handler(req), cancel, nil
+}
+
+func (t *fakeTransport) notify(path string, resp Response) {
+	t.mu.Lock()
+	notify := t.observers[path]
+	t.mu.Unlock()
+	if notify != nil {
+		notify(resp)
+	}
+}
+
+func (t *fakeTransport) sent(method byte, path string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, req := range t.requests {
+		if req.Method == method && req.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func text(s string) Response {
+	return Response{Code: codeContent, ContentFormat: FormatText, Payload: []byte(s)}
+}
+
+func TestDecode(t *testing.T) {
+	device := []byte{
+		0xC4, 0x00, 'A', 'c', 'm', 'e', // Manufacturer
+		0xC1, 0x09, 85, // Battery Level
+		0x86, 0x06, 0x41, 0x00, 0x01, 0x41, 0x01, 0x05, // Available Power Sources
+	}
+	want := []Value{
+		{Path: "/3/0/0", Value: "Acme"},
+		{Path: "/3/0/9", Value: int64(85)},
+		{Path: "/3/0/6/0", Value: int64(1)},
+		{Path: "/3/0/6/1", Value: int64(5)},
+	}
+	values, err := decode(Path{3, 0}, FormatTLV, device)
+	require.NoError(t, err)
+	assert.Equal(t, want, values)
+
+	// Reading the object wraps the instance
+	values, err = decode(Path{3}, FormatTLV, append([]byte{0x08, 0x00, byte(len(device))}, device...))
+	require.NoError(t, err)
+	assert.Equal(t, want, values)
+
+	values, err = decode(Path{3, 0}, FormatSenMLJSON, []byte(`[{"bn":"/3/0/","n":"0","vs":"Acme"},{"n":"9","v":85},{"n":"13","v":1700000000}]`))
+	require.NoError(t, err)
+	assert.Equal(t, []Value{{Path: "/3/0/0", Value: "Acme"}, {Path: "/3/0/9", Value: int64(85)}, {Path: "/3/0/13", Value: int64(1700000000)}}, values)
+
+	values, err = decode(Path{6, 0}, FormatJSON, []byte(`{"e":[{"n":"0","v":4.5},{"n":"1","v":-73.5}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, []Value{{Path: "/6/0/0", Value: 4.5}, {Path: "/6/0/1", Value: -73.5}}, values)
+
+	values, err = decode(Path{5, 0, 3}, FormatText, []byte("2"))
+	require.NoError(t, err)
+	assert.Equal(t, []Value{{Path: "/5/0/3", Value: int64(2)}}, values)
+
+	_, err = decode(Path{3, 0, 9}, FormatText, []byte("full"))
+	assert.ErrorIs(t, err, ErrDevice)
+	_, err = decode(Path{3, 0}, FormatTLV, []byte{0xC4, 0x00, 'A'})
+	assert.ErrorIs(t, err, ErrDevice)
+	_, err = decode(Path{3, 0}, 60, nil)
+	assert.ErrorIs(t, err, ErrDevice)
+}
+
+func TestEncode(t *testing.T) {
+	for _, c := range []struct {
+		path    Path
+		value   any
+		format  int
+		payload string
+	}{
+		{Path{1, 0, 1}, float64(300), FormatText, "300"},
+		{Path{1, 0, 6}, true, FormatText, "1"},
+		{Path{3, 0, 15}, "Europe/Berlin", FormatText, "Europe/Berlin"},
+		{Path{5, 0, 0}, "AQID", FormatOpaque, "\x01\x02\x03"},
+		{Path{1024, 0, 1}, "anything", FormatText, "anything"},
+	} {
+		format, payload, err := encode(c.path, c.value)
+		require.NoError(t, err, "%s", c.path)
+		assert.Equal(t, c.format, format, "%s", c.path)
+		assert.Equal(t, c.payload, string(payload), "%s", c.path)
+	}
+
+	for _, c := range []struct {
+		path  Path
+		value any
+	}{
+		{Path{3, 0}, "x"},              // not a resource
+		{Path{3, 0, 0}, "x"},           // read-only
+		{Path{3, 0, 4}, "x"},           // executable
+		{Path{1, 0, 1}, 1.5},           // not an integer
+		{Path{1, 0, 1}, "soon"},        // not a number
+		{Path{5, 0, 0}, "not base64!"}, // opaque
+		{Path{1, 0, 7}, []any{"U"}},    // not a scalar
+	} {
+		_, _, err := encode(c.path, c.value)
+		assert.ErrorIs(t, err, ErrInvalid, "%s %v", c.path, c.value)
+	}
+}
+
+func TestParseLinks(t *testing.T) {
+	root, objects := parseLinks(`</>;rt="oma.lwm2m";ct=11543,</1/0>,</3/0>,</5/0>,</3303>;ver=1.1`)
+	assert.Equal(t, "", root)
+	assert.Equal(t, []string{"/1/0", "/3/0", "/5/0", "/3303"}, objects)
+
+	root, objects = parseLinks(`</lwm2m>;rt="oma.lwm2m", </lwm2m/3/0>, <invalid>`)
+	assert.Equal(t, "/lwm2m", root)
+	assert.Equal(t, []string{"/3/0"}, objects)
+}
+
+func TestRegistration(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	server := New(Options{Now: func() time.Time { return now }})
+	ctx := context.Background()
+
+	reg, err := server.Register(ctx, RegisterRequest{Endpoint: "sensor-1", Lifetime: time.Minute, Version: "1.1", Binding: "U", Links: "</1/0>,</3/0>", Addr: "192.0.2.1:5683"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(60), reg.Lifetime)
+	assert.Equal(t, []string{"/1/0", "/3/0"}, reg.Objects)
+	assert.Equal(t, now.Add(time.Minute), reg.ExpiresAt)
+
+	// Updates extend the registration and may change the address
+	now = now.Add(50 * time.Second)
+	updated, err := server.Update(ctx, reg.ID, RegisterRequest{Addr: "192.0.2.1:40000"})
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:40000", updated.Addr)
+	assert.Equal(t, []string{"/1/0", "/3/0"}, updated.Objects)
+	assert.Equal(t, now.Add(time.Minute), updated.ExpiresAt)
+	_, err = server.Update(ctx, "unknown", RegisterRequest{})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Registrations expire without updates
+	now = now.Add(2 * time.Minute)
+	assert.Empty(t, server.Clients())
+	_, err = server.Client("sensor-1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	reg, err = server.Register(ctx, RegisterRequest{Endpoint: "sensor-1", Addr: "192.0.2.1:5683"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(DefaultLifetime/time.Second), reg.Lifetime)
+	require.Len(t, server.Clients(), 1)
+	require.NoError(t, server.Deregister(ctx, reg.ID))
+	assert.ErrorIs(t, server.Deregister(ctx, reg.ID), ErrNotFound)
+
+	_, err = server.Register(ctx, RegisterRequest{Addr: "192.0.2.1:5683"})
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestRegistrationWithDevices(t *testing.T) {
+	ca, err := devices.New(devices.Options{})
+	require.NoError(t, err)
+	ctx := context.Background()
+	_, _, err = ca.Register(ctx, "sensor-1", "", "", "admin")
+	require.NoError(t, err)
+	_, _, err = ca.Register(ctx, "sensor-2", "", "", "admin")
+	require.NoError(t, err)
+	require.NoError(t, ca.Revoke(ctx, "sensor-2", "admin"))
+
+	server := New(Options{Devices: ca})
+	_, err = server.Register(ctx, RegisterRequest{Endpoint: "sensor-1", Addr: "192.0.2.1:5683"})
+	assert.NoError(t, err)
+	_, err = server.Register(ctx, RegisterRequest{Endpoint: "sensor-2", Addr: "192.0.2.2:5683"})
+	assert.ErrorIs(t, err, ErrDenied)
+	_, err = server.Register(ctx, RegisterRequest{Endpoint: "unknown", Addr: "192.0.2.3:5683"})
+	assert.ErrorIs(t, err, ErrDenied)
+}
+
+func TestOperations(t *testing.T) {
+	transport := newFakeTransport(func(req Request) Response {
+		switch {
+		case req.Method == MethodGet && req.Path == "/lwm2m/3/0/9":
+			return text("85")
+		case req.Method == MethodGet:
+			return Response{Code: 0x84}
+		case req.Method == MethodPut && req.Path == "/lwm2m/1/0/1":
+			return Response{Code: codeChanged}
+		case req.Method == MethodPost && req.Path == "/lwm2m/3/0/4":
+			return Response{Code: codeChanged}
+		default:
+			return Response{Code: 0x85}
+		}
+	})
+	server := New(Options{})
+	ctx := context.Background()
+
+	// Operations need the transport and a registration
+	_, err := server.Read(ctx, "sensor-1", "/3/0/9")
+	assert.ErrorIs(t, err, ErrUnreachable)
+	server.Attach(transport)
+	_, err = server.Read(ctx, "sensor-1", "/3/0/9")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = server.Register(ctx, RegisterRequest{Endpoint: "sensor-1", Links: `</lwm2m>;rt="oma.lwm2m",</lwm2m/3/0>`, Addr: "192.0.2.1:5683"})
+	require.NoError(t, err)
+
+	// Requests go under the root path the device registered
+	values, err := server.Read(ctx, "sensor-1", "/3/0/9")
+	require.NoError(t, err)
+	assert.Equal(t, []Value{{Path: "/3/0/9", Value: int64(85)}}, values)
+	_, err = server.Read(ctx, "sensor-1", "/3/0/99")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = server.Read(ctx, "sensor-1", "/3/x")
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	require.NoError(t, server.Write(ctx, "sensor-1", "/1/0/1", float64(300), "admin"))
+	assert.ErrorIs(t, server.Write(ctx, "sensor-1", "/1/0/7", "UQ", "admin"), ErrNotAllowed)
+	assert.ErrorIs(t, server.Write(ctx, "sensor-1", "/3/0/0", "Acme", "admin"), ErrInvalid)
+
+	require.NoError(t, server.Execute(ctx, "sensor-1", "/3/0/4", "", "admin"))
+	assert.ErrorIs(t, server.Execute(ctx, "sensor-1", "/3/0/9", "", "admin"), ErrInvalid)
+	assert.ErrorIs(t, server.Execute(ctx, "sensor-1", "/3/0", "", "admin"), ErrInvalid)
+}
+
+func TestObserve(t *testing.T) {
+	transport := newFakeTransport(func(req Request) Response { return text("85") })
+	server := New(Options{})
+	server.Attach(transport)
+	var watched []Value
+	server.Watch(func(endpoint string, values []Value) {
+		assert.Equal(t, "sensor-1", endpoint)
+		watched = append(watched, values...)
+	})
+	ctx := context.Background()
+	reg, err := server.Register(ctx, RegisterRequest{Endpoint: "sensor-1", Links: "</3/0>", Addr: "192.0.2.1:5683"})
+	require.NoError(t, err)
+
+	values, err := server.Observe(ctx, "sensor-1", "/3/0/9")
+	require.NoError(t, err)
+	assert.Equal(t, []Value{{Path: "/3/0/9", Value: int64(85)}}, values)
+
+	transport.notify("/3/0/9", text("84"))
+	reg, err = server.Client("sensor-1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]Value{"/3/0/9": {{Path: "/3/0/9", Value: int64(84)}}}, reg.Observations)
+	assert.Equal(t, []Value{{Path: "/3/0/9", Value: int64(85)}, {Path: "/3/0/9", Value: int64(84)}}, watched, "watchers get the values read and notified")
+
+	require.NoError(t, server.CancelObservation("sensor-1", "/3/0/9"))
+	assert.ErrorIs(t, server.CancelObservation("sensor-1", "/3/0/9"), ErrNotFound)
+	transport.mu.Lock()
+	assert.Empty(t, transport.observers)
+	transport.mu.Unlock()
+
+	// Registering again ends the observations
+	_, err = server.Observe(ctx, "sensor-1", "/3/0/9")
+	require.NoError(t, err)
+	_, err = server.Register(ctx, RegisterRequest{Endpoint: "sensor-1", Links: "</3/0>", Addr: "192.0.2.1:5683"})
+	require.NoError(t, err)
+	transport.mu.Lock()
+	assert.Empty(t, transport.observers)
+	transport.mu.Unlock()
+}
+
+func TestFirmwareUpdate(t *testing.T) {
+	var mu sync.Mutex
+	state, result := 0, 0
+	transport := newFakeTransport(func(req Request) Response {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case req.Method == MethodPut && req.Path == firmwarePackageURI:
+			state, result = 1, 0
+			return Response{Code: codeChanged}
+		case req.Method == MethodPost && req.Path == firmwareUpdate:
+			state = 3
+			return Response{Code: codeChanged}
+		case req.Path == firmwareState:
+			return text(fmt.Sprint(state))
+		case req.Path == firmwareResult:
+			return text(fmt.Sprint(result))
+		default:
+			return Response{Code: 0x84}
+		}
+	})
+	server := New(Options{})
+	server.Attach(transport)
+	ctx := context.Background()
+	_, err := server.Register(ctx, RegisterRequest{Endpoint: "sensor-1", Links: "</3/0>", Addr: "192.0.2.1:5683"})
+	require.NoError(t, err)
+
+	// Devices need the Firmware Update object
+	_, err = server.UpdateFirmware(ctx, "sensor-1", "coap://fw.example/v2.bin", "admin")
+	assert.ErrorIs(t, err, ErrNotAllowed)
+
+	_, err = server.Register(ctx, RegisterRequest{Endpoint: "sensor-1", Links: "</3/0>,</5/0>", Addr: "192.0.2.1:5683"})
+	require.NoError(t, err)
+	update, err := server.UpdateFirmware(ctx, "sensor-1", "coap://fw.example/v2.bin", "admin")
+	require.NoError(t, err)
+	assert.Equal(t, FirmwareDownloading, update.Status)
+	assert.Equal(t, int64(1), update.State)
+
+	// Once downloaded, the update is executed
+	transport.notify(firmwareState, text("2"))
+	require.Eventually(t, func() bool { return transport.sent(MethodPost, firmwareUpdate) }, time.Second, 10*time.Millisecond)
+	reg, err := server.Client("sensor-1")
+	require.NoError(t, err)
+	assert.Equal(t, FirmwareUpdating, reg.Firmware.Status)
+
+	// The device registers again running the new firmware, which ends the
+	// observations; the server reads the result
+	mu.Lock()
+	state, result = 0, 1
+	mu.Unlock()
+	_, err = server.Register(ctx, RegisterRequest{Endpoint: "sensor-1", Links: "</3/0>,</5/0>", Addr: "192.0.2.1:5683"})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		reg, err := server.Client("sensor-1")
+		return err == nil && reg.Firmware.Status == FirmwareSucceeded
+	}, time.Second, 10*time.Millisecond)
+
+	// Failures are reported by the Update Result
+	_, err = server.UpdateFirmware(ctx, "sensor-1", "coap://fw.example/v3.bin", "admin")
+	require.NoError(t, err)
+	transport.notify(firmwareResult, text("5"))
+	reg, err = server.Client("sensor-1")
+	require.NoError(t, err)
+	assert.Equal(t, FirmwareFailed, reg.Firmware.Status)
+	assert.Equal(t, int64(5), reg.Firmware.Result)
+}
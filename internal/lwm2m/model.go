@@ -0,0 +1,175 @@
+package lwm2m
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Type is the data type of a resource
+type Type string
+
+const (
+	String   Type = "string"
+	Integer  Type = "integer"
+	Unsigned Type = "unsigned"
+	Float    Type = "float"
+	Boolean  Type = "boolean"
+	Opaque   Type = "opaque"
+	Time     Type = "time"
+	ObjLnk   Type = "objlnk"
+	// None is the type of executable resources
+	None Type = "none"
+)
+
+// ResourceDef describes a resource of an object
+type ResourceDef struct {
+	Name string `json:"name"`
+	Type Type   `json:"type"`
+	// Multiple resources hold resource instances
+	Multiple bool `json:"multiple,omitempty"`
+	// Operations are R, W, RW or E
+	Operations string `json:"operations"`
+}
+
+// ObjectDef describes an object of the OMA LwM2M registry
+type ObjectDef struct {
+	ID   uint16 `json:"id"`
+	Name string `json:"name"`
+	// Multiple objects may have several instances
+	Multiple  bool                   `json:"multiple,omitempty"`
+	Resources map[uint16]ResourceDef `json:"resources"`
+}
+
+// Objects are the core objects whose resources values are decoded by
+// type; the resources of other objects are decoded as text or bytes
+var Objects = map[uint16]ObjectDef{
+	1: {ID: 1, Name: "LwM2M Server", Multiple: true, Resources: map[uint16]ResourceDef{
+		0: {Name: "Short Server ID", Type: Integer, Operations: "R"},
+		1: {Name: "Lifetime", Type: Integer, Operations: "RW"},
+		2: {Name: "Default Minimum Period", Type: Integer, Operations: "RW"},
+		3: {Name: "Default Maximum Period", Type: Integer, Operations: "RW"},
+		4: {Name: "Disable", Type: None, Operations: "E"},
+		5: {Name: "Disable Timeout", Type: Integer, Operations: "RW"},
+		6: {Name: "Notification Storing When Disabled or Offline", Type: Boolean, Operations: "RW"},
+		7: {Name: "Binding", Type: String, Operations: "RW"},
+		8: {Name: "Registration Update Trigger", Type: None, Operations: "E"},
+	}},
+	3: {ID: 3, Name: "Device", Resources: map[uint16]ResourceDef{
+		0:  {Name: "Manufacturer", Type: String, Operations: "R"},
+		1:  {Name: "Model Number", Type: String, Operations: "R"},
+		2:  {Name: "Serial Number", Type: String, Operations: "R"},
+		3:  {Name: "Firmware Version", Type: String, Operations: "R"},
+		4:  {Name: "Reboot", Type: None, Operations: "E"},
+		5:  {Name: "Factory Reset", Type: None, Operations: "E"},
+		6:  {Name: "Available Power Sources", Type: Integer, Multiple: true, Operations: "R"},
+		7:  {Name: "Power Source Voltage", Type: Integer, Multiple: true, Operations: "R"},
+		8:  {Name: "Power Source Current", Type: Integer, Multiple: true, Operations: "R"},
+		9:  {Name: "Battery Level", Type: Integer, Operations: "R"},
+		10: {Name: "Memory Free", Type: Integer, Operations: "R"},
+		11: {Name: "Error Code", Type: Integer, Multiple: true, Operations: "R"},
+		12: {Name: "Reset Error Code", Type: None, Operations: "E"},
+		13: {Name: "Current Time", Type: Time, Operations: "RW"},
+		14: {Name: "UTC Offset", Type: String, Operations: "RW"},
+		15: {Name: "Timezone", Type: String, Operations: "RW"},
+		16: {Name: "Supported Binding and Modes", Type: String, Operations: "R"},
+		17: {Name: "Device Type", Type: String, Operations: "R"},
+		18: {Name: "Hardware Version", Type: String, Operations: "R"},
+		19: {Name: "Software Version", Type: String, Operations: "R"},
+		20: {Name: "Battery Status", Type: Integer, Operations: "R"},
+		21: {Name: "Memory Total", Type: Integer, Operations: "R"},
+	}},
+	4: {ID: 4, Name: "Connectivity Monitoring", Resources: map[uint16]ResourceDef{
+		0:  {Name: "Network Bearer", Type: Integer, Operations: "R"},
+		1:  {Name: "Available Network Bearer", Type: Integer, Multiple: true, Operations: "R"},
+		2:  {Name: "Radio Signal Strength", Type: Integer, Operations: "R"},
+		3:  {Name: "Link Quality", Type: Integer, Operations: "R"},
+		4:  {Name: "IP Addresses", Type: String, Multiple: true, Operations: "R"},
+		5:  {Name: "Router IP Addresses", Type: String, Multiple: true, Operations: "R"},
+		6:  {Name: "Link Utilization", Type: Integer, Operations: "R"},
+		7:  {Name: "APN", Type: String, Multiple: true, Operations: "R"},
+		8:  {Name: "Cell ID", Type: Integer, Operations: "R"},
+		9:  {Name: "SMNC", Type: Integer, Operations: "R"},
+		10: {Name: "SMCC", Type: Integer, Operations: "R"},
+	}},
+	5: {ID: 5, Name: "Firmware Update", Resources: map[uint16]ResourceDef{
+		0: {Name: "Package", Type: Opaque, Operations: "W"},
+		1: {Name: "Package URI", Type: String, Operations: "RW"},
+		2: {Name: "Update", Type: None, Operations: "E"},
+		3: {Name: "State", Type: Integer, Operations: "R"},
+		5: {Name: "Update Result", Type: Integer, Operations: "R"},
+		6: {Name: "PkgName", Type: String, Operations: "R"},
+		7: {Name: "PkgVersion", Type: String, Operations: "R"},
+		8: {Name: "Firmware Update Protocol Support", Type: Integer, Multiple: true, Operations: "R"},
+		9: {Name: "Firmware Update Delivery Method", Type: Integer, Operations: "R"},
+	}},
+	6: {ID: 6, Name: "Location", Resources: map[uint16]ResourceDef{
+		0: {Name: "Latitude", Type: Float, Operations: "R"},
+		1: {Name: "Longitude", Type: Float, Operations: "R"},
+		2: {Name: "Altitude", Type: Float, Operations: "R"},
+		3: {Name: "Radius", Type: Float, Operations: "R"},
+		4: {Name: "Velocity", Type: Opaque, Operations: "R"},
+		5: {Name: "Timestamp", Type: Time, Operations: "R"},
+		6: {Name: "Speed", Type: Float, Operations: "R"},
+	}},
+}
+
+// Firmware Update object resources, and the values of its State and
+// Update Result
+const (
+	firmwarePackageURI = "/5/0/1"
+	firmwareUpdate     = "/5/0/2"
+	firmwareState      = "/5/0/3"
+	firmwareResult     = "/5/0/5"
+
+	stateDownloaded = 2
+	resultSuccess   = 1
+)
+
+// Path is an LwM2M path: an object, an object instance, a resource or a
+// resource instance
+type Path []uint16
+
+// ParsePath parses a path such as /3/0/1, the leading slash being optional
+func ParsePath(s string) (Path, error) {
+	s = strings.Trim(s, "/")
+	if s == "" {
+		return nil, fmt.Errorf("%w: empty path", ErrInvalid)
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) > 4 {
+		return nil, fmt.Errorf("%w: path %q has more than 4 levels", ErrInvalid, s)
+	}
+	path := make(Path, len(parts))
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid path %q", ErrInvalid, s)
+		}
+		path[i] = uint16(n)
+	}
+	return path, nil
+}
+
+func (p Path) String() string {
+	var b strings.Builder
+	for _, id := range p {
+		b.WriteByte('/')
+		b.WriteString(strconv.Itoa(int(id)))
+	}
+	return b.String()
+}
+
+// child returns the path of the child id of p
+func (p Path) child(id uint16) Path {
+	return append(p[:len(p):len(p)], id)
+}
+
+// resource returns the definition of the resource p is or is within
+func (p Path) resource() (ResourceDef, bool) {
+	if len(p) < 3 {
+		return ResourceDef{}, false
+	}
+	def, ok := Objects[p[0]].Resources[p[2]]
+	return def, ok
+}
@@ -0,0 +1,763 @@
+// Package lwm2m manages IoT devices with OMA LwM2M. Devices register over
+// CoAP with the registration interface the CoAP API serves under /rd; the
+// node then reads, writes, executes and observes the resources of their
+// objects, and updates their firmware through the Firmware Update object.
+// Values are decoded by the object model of the core objects from plain
+// text, OMA-TLV, SenML JSON and LwM2M JSON.
+package lwm2m
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/audit"
+	"github.com/Skpow1234/Peervault/internal/devices"
+)
+
+var (
+	// ErrNotFound is returned for devices that are not registered and for
+	// resources they do not have
+	ErrNotFound = errors.New("lwm2m: not found")
+	// ErrInvalid is returned for malformed paths, values and registrations
+	ErrInvalid = errors.New("lwm2m: invalid request")
+	// ErrDenied is returned for registrations of unknown or revoked devices
+	// and for operations devices refuse
+	ErrDenied = errors.New("lwm2m: denied")
+	// ErrNotAllowed is returned for operations a resource does not support
+	ErrNotAllowed = errors.New("lwm2m: operation not allowed")
+	// ErrUnreachable is returned when a device does not answer, or the
+	// CoAP API is not serving
+	ErrUnreachable = errors.New("lwm2m: device unreachable")
+	// ErrDevice is returned for answers the server cannot use
+	ErrDevice = errors.New("lwm2m: device error")
+)
+
+// DefaultLifetime is the lifetime of registrations that do not set one
+const DefaultLifetime = 86400 * time.Second
+
+// CoAP methods and the response codes operations check
+const (
+	MethodGet    = 1
+	MethodPost   = 2
+	MethodPut    = 3
+	MethodDelete = 4
+
+	codeChanged = 0x44
+	codeContent = 0x45
+)
+
+// Request is a CoAP request to a device
+type Request struct {
+	Method byte
+	// Path is the URI path, such as /3/0/1
+	Path string
+	// ContentFormat is that of Payload; negative for none
+	ContentFormat int
+	Payload       []byte
+}
+
+// Response is the answer of a device
+type Response struct {
+	// Code is the CoAP response code, such as 0x45 for 2.05 Content
+	Code byte
+	// ContentFormat is that of Payload; negative when the device set none
+	ContentFormat int
+	Payload       []byte
+}
+
+// Transport sends requests to devices, as the CoAP API does
+type Transport interface {
+	// Send sends req to the device at addr and returns its response
+	Send(ctx context.Context, addr string, req Request) (Response, error)
+	// Observe sends req as an observation: notify receives the
+	// notifications after the response until cancel is called
+	Observe(ctx context.Context, addr string, req Request, notify func(Response)) (resp Response, cancel func(), err error)
+}
+
+// RegisterRequest is a registration or registration update of a device
+type RegisterRequest struct {
+	// Endpoint is the endpoint client name (ep)
+	Endpoint string
+	// Lifetime (lt) is how long the registration lasts without update;
+	// zero keeps the current one, or DefaultLifetime
+	Lifetime time.Duration
+	// Version (lwm2m) and Binding (b) are those the device announced
+	Version string
+	Binding string
+	// Links is the CoRE link format payload listing the device's objects;
+	// empty keeps the current ones
+	Links string
+	// Addr is the UDP address of the device
+	Addr string
+}
+
+// Registration is a device registered with the node
+type Registration struct {
+	ID       string `json:"id"`
+	Endpoint string `json:"endpoint"`
+	Addr     string `json:"addr"`
+	// Lifetime is in seconds
+	Lifetime int64  `json:"lifetime"`
+	Version  string `json:"version,omitempty"`
+	Binding  string `json:"binding,omitempty"`
+	// Root is the alternate path the device serves its objects under
+	Root string `json:"root,omitempty"`
+	// Objects are the objects and object instances of the device
+	Objects      []string  `json:"objects"`
+	RegisteredAt time.Time `json:"registered_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	// Observations are the latest values of the observed paths
+	Observations map[string][]Value `json:"observations,omitempty"`
+	// Firmware is the last firmware update started on the device
+	Firmware *FirmwareUpdate `json:"firmware,omitempty"`
+}
+
+// Firmware update statuses
+const (
+	FirmwareDownloading = "downloading"
+	FirmwareUpdating    = "updating"
+	FirmwareSucceeded   = "succeeded"
+	FirmwareFailed      = "failed"
+)
+
+// FirmwareUpdate is the progress of a firmware update
+type FirmwareUpdate struct {
+	PackageURI string `json:"package_uri"`
+	Status     string `json:"status"`
+	// State and Result are the State (/5/0/3) and Update Result (/5/0/5)
+	// resources the device last reported
+	State     int64     `json:"state"`
+	Result    int64     `json:"result"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Options configures a Server
+type Options struct {
+	// Devices, when set, only lets devices registered with the device CA
+	// and not revoked register, by their device ID as endpoint name
+	Devices *devices.CA
+	// Audit receives refused registrations and the operations changing
+	// devices; nil uses the global audit logger
+	Audit *audit.AuditLogger
+	// Now returns the current time; nil uses time.Now
+	Now func() time.Time
+	// Logger defaults to slog.Default
+	Logger *slog.Logger
+}
+
+// client is a registration with the observations the server holds
+type client struct {
+	Registration
+	cancels map[string]func()
+}
+
+// Server holds the registrations of the devices and runs the device
+// management operations on them
+type Server struct {
+	opts Options
+
+	mu        sync.Mutex
+	transport Transport
+	clients   map[string]*client // by endpoint
+	// firmware outlives registrations, as devices register again after
+	// updating
+	firmware map[string]*FirmwareUpdate
+	watchers []func(endpoint string, values []Value)
+}
+
+// New returns a Server without registrations
+func New(opts Options) *Server {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return &Server{opts: opts, clients: make(map[string]*client), firmware: make(map[string]*FirmwareUpdate)}
+}
+
+// Attach sets the transport operations reach devices through
+func (s *Server) Attach(t Transport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transport = t
+}
+
+// Watch calls fn with the values of every observed path a device reads
+// or notifies, such as to record them as telemetry
+func (s *Server) Watch(fn func(endpoint string, values []Value)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchers = append(s.watchers, fn)
+}
+
+// Register registers a device, replacing its previous registration
+func (s *Server) Register(ctx context.Context, req RegisterRequest) (Registration, error) {
+	if req.Endpoint == "" || len(req.Endpoint) > 255 {
+		return Registration{}, fmt.Errorf("%w: endpoint name must have 1 to 255 bytes", ErrInvalid)
+	}
+	if err := s.checkDevice(req.Endpoint); err != nil {
+		s.auditDenied(ctx, req, err)
+		return Registration{}, err
+	}
+	id, err := newID()
+	if err != nil {
+		return Registration{}, err
+	}
+	if req.Lifetime <= 0 {
+		req.Lifetime = DefaultLifetime
+	}
+	now := s.opts.Now().UTC()
+	root, objects := parseLinks(req.Links)
+	c := &client{Registration: Registration{
+		ID:           id,
+		Endpoint:     req.Endpoint,
+		Addr:         req.Addr,
+		Lifetime:     int64(req.Lifetime / time.Second),
+		Version:      req.Version,
+		Binding:      req.Binding,
+		Root:         root,
+		Objects:      objects,
+		RegisteredAt: now,
+		UpdatedAt:    now,
+		ExpiresAt:    now.Add(req.Lifetime),
+	}, cancels: make(map[string]func())}
+
+	s.mu.Lock()
+	if old := s.clients[req.Endpoint]; old != nil {
+		old.cancelAll()
+	}
+	s.clients[req.Endpoint] = c
+	firmware := s.firmware[req.Endpoint]
+	updating := firmware != nil && firmware.Status == FirmwareUpdating
+	reg := c.snapshot(firmware)
+	s.mu.Unlock()
+
+	// A device registers again once its new firmware runs, and reports
+	// how the update went
+	if updating {
+		go s.checkFirmware(req.Endpoint)
+	}
+	return reg, nil
+}
+
+// Update updates the registration id of a device, as it does before its
+// lifetime runs out
+func (s *Server) Update(ctx context.Context, id string, req RegisterRequest) (Registration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.byIDLocked(id)
+	if c == nil {
+		return Registration{}, fmt.Errorf("%w: registration %s", ErrNotFound, id)
+	}
+	now := s.opts.Now().UTC()
+	if req.Lifetime > 0 {
+		c.Lifetime = int64(req.Lifetime / time.Second)
+	}
+	if req.Binding != "" {
+		c.Binding = req.Binding
+	}
+	if req.Links != "" {
+		c.Root, c.Objects = parseLinks(req.Links)
+	}
+	if req.Addr != "" {
+		c.Addr = req.Addr
+	}
+	c.UpdatedAt = now
+	c.ExpiresAt = now.Add(time.Duration(c.Lifetime) * time.Second)
+	return c.snapshot(s.firmware[c.Endpoint]), nil
+}
+
+// Deregister removes the registration id
+func (s *Server) Deregister(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.byIDLocked(id)
+	if c == nil {
+		return fmt.Errorf("%w: registration %s", ErrNotFound, id)
+	}
+	c.cancelAll()
+	delete(s.clients, c.Endpoint)
+	return nil
+}
+
+// Clients returns the registrations that have not expired, by endpoint
+// name
+func (s *Server) Clients() []Registration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked()
+	list := make([]Registration, 0, len(s.clients))
+	for _, c := range s.clients {
+		list = append(list, c.snapshot(s.firmware[c.Endpoint]))
+	}
+	slices.SortFunc(list, func(a, b Registration) int { return strings.Compare(a.Endpoint, b.Endpoint) })
+	return list
+}
+
+// Client returns the registration of a device
+func (s *Server) Client(endpoint string) (Registration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked()
+	c := s.clients[endpoint]
+	if c == nil {
+		return Registration{}, fmt.Errorf("%w: device %s is not registered", ErrNotFound, endpoint)
+	}
+	return c.snapshot(s.firmware[endpoint]), nil
+}
+
+// Read reads an object, object instance, resource or resource instance
+func (s *Server) Read(ctx context.Context, endpoint, path string) ([]Value, error) {
+	p, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.send(ctx, endpoint, Request{Method: MethodGet, Path: p.String(), ContentFormat: -1})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCode(resp, codeContent); err != nil {
+		return nil, err
+	}
+	return decode(p, resp.ContentFormat, resp.Payload)
+}
+
+// Write writes a resource. Values are strings, float64 numbers or bools
+// as JSON decodes them, and base64 strings for opaque resources.
+func (s *Server) Write(ctx context.Context, endpoint, path string, value any, actor string) error {
+	p, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	format, payload, err := encode(p, value)
+	if err != nil {
+		return err
+	}
+	resp, err := s.send(ctx, endpoint, Request{Method: MethodPut, Path: p.String(), ContentFormat: format, Payload: payload})
+	if err == nil {
+		err = checkCode(resp, codeChanged)
+	}
+	s.auditOperation(ctx, "lwm2m_write", endpoint, p.String(), actor, err)
+	return err
+}
+
+// Execute executes a resource, such as /3/0/4 to reboot a device, with
+// optional arguments
+func (s *Server) Execute(ctx context.Context, endpoint, path, args, actor string) error {
+	p, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	if len(p) != 3 {
+		return fmt.Errorf("%w: only resources can be executed, not %s", ErrInvalid, p)
+	}
+	if def, ok := p.resource(); ok && def.Type != None {
+		return fmt.Errorf("%w: %s (%s) is not executable", ErrInvalid, p, def.Name)
+	}
+	req := Request{Method: MethodPost, Path: p.String(), ContentFormat: -1}
+	if args != "" {
+		req.ContentFormat, req.Payload = FormatText, []byte(args)
+	}
+	resp, err := s.send(ctx, endpoint, req)
+	if err == nil {
+		err = checkCode(resp, codeChanged)
+	}
+	s.auditOperation(ctx, "lwm2m_execute", endpoint, p.String(), actor, err)
+	return err
+}
+
+// Observe observes a path, returning its current values. The values the
+// device notifies replace them in the registration's observations.
+func (s *Server) Observe(ctx context.Context, endpoint, path string) ([]Value, error) {
+	p, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	return s.observe(ctx, endpoint, p, nil)
+}
+
+// CancelObservation stops observing a path
+func (s *Server) CancelObservation(endpoint, path string) error {
+	p, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.clients[endpoint]
+	if c == nil {
+		return fmt.Errorf("%w: device %s is not registered", ErrNotFound, endpoint)
+	}
+	cancel, ok := c.cancels[p.String()]
+	if !ok {
+		return fmt.Errorf("%w: %s is not observed", ErrNotFound, p)
+	}
+	cancel()
+	delete(c.cancels, p.String())
+	delete(c.Observations, p.String())
+	return nil
+}
+
+// UpdateFirmware has a device download the firmware at packageURI and
+// install it once downloaded. Its progress is in the registration's
+// Firmware.
+func (s *Server) UpdateFirmware(ctx context.Context, endpoint, packageURI, actor string) (FirmwareUpdate, error) {
+	if packageURI == "" {
+		return FirmwareUpdate{}, fmt.Errorf("%w: package URI required", ErrInvalid)
+	}
+	reg, err := s.Client(endpoint)
+	if err != nil {
+		return FirmwareUpdate{}, err
+	}
+	if !slices.ContainsFunc(reg.Objects, func(o string) bool { return o == "/5" || strings.HasPrefix(o, "/5/") }) {
+		return FirmwareUpdate{}, fmt.Errorf("%w: %s has no Firmware Update object", ErrNotAllowed, endpoint)
+	}
+
+	now := s.opts.Now().UTC()
+	s.mu.Lock()
+	s.firmware[endpoint] = &FirmwareUpdate{PackageURI: packageURI, Status: FirmwareDownloading, StartedAt: now, UpdatedAt: now}
+	s.mu.Unlock()
+
+	// Writing the package URI resets the Update Result of the previous
+	// update; the device then reports its progress in State and Update
+	// Result
+	if err := s.Write(ctx, endpoint, firmwarePackageURI, packageURI, actor); err != nil {
+		s.failFirmware(endpoint)
+		return FirmwareUpdate{}, err
+	}
+	for _, path := range []string{firmwareState, firmwareResult} {
+		p, _ := ParsePath(path)
+		if _, err := s.observe(ctx, endpoint, p, s.firmwareProgress); err != nil {
+			s.failFirmware(endpoint)
+			return FirmwareUpdate{}, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.firmware[endpoint], nil
+}
+
+// observe observes p, passing the values notified to progress too
+func (s *Server) observe(ctx context.Context, endpoint string, p Path, progress func(string, []Value)) ([]Value, error) {
+	transport, reg, err := s.target(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	key := p.String()
+	notify := func(resp Response) {
+		if resp.Code != codeContent && resp.Code != codeChanged {
+			return
+		}
+		values, err := decode(p, resp.ContentFormat, resp.Payload)
+		if err != nil {
+			s.opts.Logger.Warn("Invalid LwM2M notification", "endpoint", endpoint, "path", key, "error", err)
+			return
+		}
+		s.setObservation(reg.ID, key, values)
+		if progress != nil {
+			progress(endpoint, values)
+		}
+	}
+	resp, cancel, err := transport.Observe(ctx, reg.Addr, Request{Method: MethodGet, Path: reg.Root + key, ContentFormat: -1}, notify)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+	if err := checkCode(resp, codeContent); err != nil {
+		cancel()
+		return nil, err
+	}
+	values, err := decode(p, resp.ContentFormat, resp.Payload)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	s.mu.Lock()
+	c := s.clients[endpoint]
+	if c == nil || c.ID != reg.ID {
+		s.mu.Unlock()
+		cancel()
+		return nil, fmt.Errorf("%w: device %s registered again", ErrUnreachable, endpoint)
+	}
+	if previous, ok := c.cancels[key]; ok {
+		previous()
+	}
+	c.cancels[key] = cancel
+	s.mu.Unlock()
+
+	s.setObservation(reg.ID, key, values)
+	if progress != nil {
+		progress(endpoint, values)
+	}
+	return values, nil
+}
+
+// setObservation records the values of an observed path and passes them
+// to the watchers
+func (s *Server) setObservation(id, path string, values []Value) {
+	s.mu.Lock()
+	c := s.byIDLocked(id)
+	if c == nil {
+		s.mu.Unlock()
+		return
+	}
+	if c.Observations == nil {
+		c.Observations = make(map[string][]Value)
+	}
+	c.Observations[path] = values
+	endpoint, watchers := c.Endpoint, s.watchers
+	s.mu.Unlock()
+	for _, fn := range watchers {
+		fn(endpoint, values)
+	}
+}
+
+// firmwareProgress follows the State and Update Result a device reports,
+// starting the update once the package is downloaded
+func (s *Server) firmwareProgress(endpoint string, values []Value) {
+	s.mu.Lock()
+	fw := s.firmware[endpoint]
+	if fw == nil || (fw.Status != FirmwareDownloading && fw.Status != FirmwareUpdating) {
+		s.mu.Unlock()
+		return
+	}
+	for _, v := range values {
+		n, ok := v.Value.(int64)
+		if !ok {
+			continue
+		}
+		switch v.Path {
+		case firmwareState:
+			fw.State = n
+		case firmwareResult:
+			fw.Result = n
+		}
+	}
+	fw.UpdatedAt = s.opts.Now().UTC()
+	execute := false
+	switch {
+	case fw.Result == resultSuccess && fw.Status == FirmwareUpdating:
+		fw.Status = FirmwareSucceeded
+	case fw.Result > resultSuccess:
+		fw.Status = FirmwareFailed
+	case fw.State == stateDownloaded && fw.Status == FirmwareDownloading:
+		fw.Status = FirmwareUpdating
+		execute = true
+	}
+	status, result := fw.Status, fw.Result
+	s.mu.Unlock()
+
+	if execute {
+		// Notifications arrive on the transport's goroutine, which must
+		// not wait for the device
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			if err := s.Execute(ctx, endpoint, firmwareUpdate, "", "lwm2m"); err != nil {
+				s.opts.Logger.Warn("Failed to start LwM2M firmware update", "endpoint", endpoint, "error", err)
+				s.failFirmware(endpoint)
+			}
+		}()
+	}
+	if status == FirmwareSucceeded || status == FirmwareFailed {
+		s.opts.Logger.Info("LwM2M firmware update finished", "endpoint", endpoint, "status", status, "result", result)
+	}
+}
+
+// checkFirmware reads the Update Result of a device that registered again
+// while updating
+func (s *Server) checkFirmware(endpoint string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	values, err := s.Read(ctx, endpoint, firmwareResult)
+	if err != nil {
+		s.opts.Logger.Warn("Failed to read LwM2M firmware update result", "endpoint", endpoint, "error", err)
+		return
+	}
+	s.firmwareProgress(endpoint, values)
+}
+
+// failFirmware marks the firmware update of a device failed
+func (s *Server) failFirmware(endpoint string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if fw := s.firmware[endpoint]; fw != nil {
+		fw.Status = FirmwareFailed
+		fw.UpdatedAt = s.opts.Now().UTC()
+	}
+}
+
+// send sends a request to a registered device, under its root path
+func (s *Server) send(ctx context.Context, endpoint string, req Request) (Response, error) {
+	transport, reg, err := s.target(endpoint)
+	if err != nil {
+		return Response{}, err
+	}
+	req.Path = reg.Root + req.Path
+	resp, err := transport.Send(ctx, reg.Addr, req)
+	if err != nil {
+		return Response{}, fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+	return resp, nil
+}
+
+// target returns the transport and the registration of a device
+func (s *Server) target(endpoint string) (Transport, Registration, error) {
+	s.mu.Lock()
+	transport := s.transport
+	s.mu.Unlock()
+	if transport == nil {
+		return nil, Registration{}, fmt.Errorf("%w: the CoAP API is not serving", ErrUnreachable)
+	}
+	reg, err := s.Client(endpoint)
+	return transport, reg, err
+}
+
+// checkCode maps the response codes of failed operations to errors
+func checkCode(resp Response, want byte) error {
+	switch code := resp.Code; {
+	case code == want || (want == codeChanged && code == codeContent):
+		return nil
+	case code == 0x84: // 4.04
+		return fmt.Errorf("%w: the device has no such resource", ErrNotFound)
+	case code == 0x85: // 4.05
+		return fmt.Errorf("%w: the resource does not support it", ErrNotAllowed)
+	case code == 0x81 || code == 0x83: // 4.01, 4.03
+		return fmt.Errorf("%w: the device refused", ErrDenied)
+	case code == 0x80 || code == 0x86 || code == 0x8F: // 4.00, 4.06, 4.15
+		return fmt.Errorf("%w: the device answered %d.%02d", ErrInvalid, code>>5, code&0x1F)
+	default:
+		return fmt.Errorf("%w: the device answered %d.%02d", ErrDevice, code>>5, code&0x1F)
+	}
+}
+
+// checkDevice checks that the device CA, if any, knows the endpoint
+func (s *Server) checkDevice(endpoint string) error {
+	if s.opts.Devices == nil {
+		return nil
+	}
+	device, err := s.opts.Devices.Device(endpoint)
+	if err != nil {
+		return fmt.Errorf("%w: %s is not a registered device", ErrDenied, endpoint)
+	}
+	if device.RevokedAt != nil {
+		return fmt.Errorf("%w: device %s is revoked", ErrDenied, endpoint)
+	}
+	return nil
+}
+
+func (s *Server) byIDLocked(id string) *client {
+	for _, c := range s.clients {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// expireLocked drops the registrations whose lifetime ran out
+func (s *Server) expireLocked() {
+	now := s.opts.Now()
+	for endpoint, c := range s.clients {
+		if now.After(c.ExpiresAt) {
+			c.cancelAll()
+			delete(s.clients, endpoint)
+		}
+	}
+}
+
+func (c *client) cancelAll() {
+	for _, cancel := range c.cancels {
+		cancel()
+	}
+	clear(c.cancels)
+}
+
+// snapshot returns a copy of the registration with its firmware update
+func (c *client) snapshot(firmware *FirmwareUpdate) Registration {
+	reg := c.Registration
+	reg.Objects = slices.Clone(c.Objects)
+	reg.Observations = maps.Clone(c.Observations)
+	if firmware != nil {
+		fw := *firmware
+		reg.Firmware = &fw
+	}
+	return reg
+}
+
+func newID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (s *Server) auditLogger() *audit.AuditLogger {
+	if s.opts.Audit != nil {
+		return s.opts.Audit
+	}
+	return audit.GlobalAuditLogger
+}
+
+// auditDenied records a refused registration
+func (s *Server) auditDenied(ctx context.Context, req RegisterRequest, reason error) {
+	logger := s.auditLogger()
+	if logger == nil {
+		s.opts.Logger.Warn("LwM2M registration denied", "endpoint", req.Endpoint, "addr", req.Addr, "reason", reason)
+		return
+	}
+	host := req.Addr
+	if i := strings.LastIndexByte(host, ':'); i >= 0 {
+		host = strings.Trim(host[:i], "[]")
+	}
+	event := &audit.AuditEvent{
+		Type:      audit.AuditEventTypeSecurity,
+		Level:     audit.AuditLevelWarning,
+		IPAddress: host,
+		Resource:  req.Endpoint,
+		Action:    "lwm2m_register",
+		Result:    "denied",
+		Message:   fmt.Sprintf("LwM2M registration of %s from %s denied: %v", req.Endpoint, req.Addr, reason),
+		Details:   map[string]interface{}{"endpoint": req.Endpoint, "addr": req.Addr},
+		Source:    "lwm2m",
+		Category:  "devices",
+		Tags:      []string{"lwm2m", "denied"},
+	}
+	if err := logger.LogEvent(ctx, event); err != nil {
+		s.opts.Logger.Warn("lwm2m: failed to write audit event", "error", err)
+	}
+}
+
+// auditOperation records a write or execution on a device
+func (s *Server) auditOperation(ctx context.Context, action, endpoint, path, actor string, opErr error) {
+	result := "success"
+	if opErr != nil {
+		result = "failure"
+	}
+	logger := s.auditLogger()
+	if logger == nil {
+		s.opts.Logger.Info("LwM2M operation", "action", action, "endpoint", endpoint, "path", path, "actor", actor, "result", result)
+		return
+	}
+	details := map[string]interface{}{"endpoint": endpoint, "path": path}
+	if opErr != nil {
+		details["error"] = opErr.Error()
+	}
+	if err := logger.LogAdminEvent(ctx, actor, action, result, details); err != nil {
+		s.opts.Logger.Warn("lwm2m: failed to write audit event", "error", err)
+	}
+}
@@ -8,9 +8,10 @@ import (
 // ToDTO converts domain metadata to a wire/message DTO used for broadcasting.
 func ToDTO(meta domain.FileMetadata) dto.StoreFile {
 	return dto.StoreFile{
-		ID:   string(meta.ID),
-		Key:  meta.HashedKey,
-		Size: meta.Size,
+		ID:      string(meta.ID),
+		Key:     meta.HashedKey,
+		Size:    meta.Size,
+		UserKey: meta.LogicalKey,
 	}
 }
 
@@ -79,6 +79,21 @@ func TestToDTO(t *testing.T) {
 				Size: 256,
 			},
 		},
+		{
+			name: "with logical key",
+			meta: domain.FileMetadata{
+				ID:         domain.NodeID("test-id-123"),
+				LogicalKey: "reports/q1.pdf",
+				HashedKey:  "hashed-key-456",
+				Size:       1024,
+			},
+			expected: dto.StoreFile{
+				ID:      "test-id-123",
+				Key:     "hashed-key-456",
+				Size:    1024,
+				UserKey: "reports/q1.pdf",
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,362 @@
+// Package media makes previews of stored images, videos and audio. A
+// Pipeline reads the dimensions of images, and the dimensions and duration
+// of videos, when they are stored, and renders thumbnails at a fixed set of
+// sizes, on ingest or on first request. Videos and audio are streamed over
+// HLS, transcoded segment by segment to a set of profiles. Thumbnails and
+// segments are derived objects stored next to the content under
+// DerivedPrefix and StreamPrefix; ffmpeg reads videos and audio. With a
+// job queue, thumbnails rendered on ingest are rendered by queued jobs,
+// which are retried until they succeed.
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/queue"
+
+	// Decoders of the image formats thumbnails are made of
+	_ "image/gif"
+	_ "image/png"
+)
+
+// Metadata keys the media information of a file is recorded under
+const (
+	MetaKind     = "pv-media-kind"
+	MetaWidth    = "pv-media-width"
+	MetaHeight   = "pv-media-height"
+	MetaDuration = "pv-media-duration"
+)
+
+// Kinds of media
+const (
+	KindImage = "image"
+	KindVideo = "video"
+	KindAudio = "audio"
+)
+
+const (
+	// DerivedPrefix is the namespace thumbnails are stored under
+	DerivedPrefix = ".thumbnails/"
+	// DefaultQuality is the JPEG quality of thumbnails
+	DefaultQuality = 80
+	// DefaultTimeout bounds each run of ffmpeg or ffprobe
+	DefaultTimeout = 30 * time.Second
+	// ThumbnailJob is the kind of queued jobs rendering the thumbnails of
+	// a file
+	ThumbnailJob = "thumbnail"
+)
+
+// DefaultSizes are the longest edges thumbnails are made at
+var DefaultSizes = []int{64, 256, 1024}
+
+var (
+	// ErrUnsupported is returned for content no preview can be made of
+	ErrUnsupported = errors.New("media: unsupported content")
+	// ErrSize is returned for thumbnail sizes the pipeline does not make
+	ErrSize = errors.New("media: unsupported thumbnail size")
+)
+
+// Store holds derived objects
+type Store interface {
+	Has(key string) bool
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, data []byte) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Config selects what the pipeline makes
+type Config struct {
+	// Sizes are the longest edges in pixels; DefaultSizes when empty
+	Sizes []int `yaml:"sizes" json:"sizes"`
+	// OnIngest renders every size when a file is stored instead of on the
+	// first request for it
+	OnIngest bool `yaml:"on_ingest" json:"on_ingest"`
+	// FFmpeg and FFprobe are the binaries videos are read with; videos get
+	// no previews when FFmpeg is empty
+	FFmpeg  string `yaml:"ffmpeg" json:"ffmpeg"`
+	FFprobe string `yaml:"ffprobe" json:"ffprobe"`
+	// Quality is the JPEG quality of thumbnails, DefaultQuality when zero
+	Quality int `yaml:"quality" json:"quality"`
+	// Timeout bounds each run of ffmpeg or ffprobe
+	Timeout time.Duration `yaml:"timeout" json:"timeout"`
+	// Profiles are the renditions videos and audio are streamed in;
+	// DefaultProfiles when empty
+	Profiles []Profile `yaml:"profiles" json:"profiles"`
+	// SegmentSeconds is the length of stream segments,
+	// DefaultSegmentSeconds when zero
+	SegmentSeconds int `yaml:"segment_seconds" json:"segment_seconds"`
+}
+
+// Info describes a media file
+type Info struct {
+	Kind     string
+	Width    int
+	Height   int
+	Duration time.Duration
+}
+
+// Metadata returns the metadata entries recording info
+func (i Info) Metadata() map[string]string {
+	meta := map[string]string{MetaKind: i.Kind}
+	if i.Kind != KindAudio {
+		meta[MetaWidth] = strconv.Itoa(i.Width)
+		meta[MetaHeight] = strconv.Itoa(i.Height)
+	}
+	if i.Kind != KindImage {
+		meta[MetaDuration] = strconv.FormatFloat(i.Duration.Seconds(), 'f', 3, 64)
+	}
+	return meta
+}
+
+// Pipeline probes media files and makes their thumbnails
+type Pipeline struct {
+	cfg    Config
+	store  Store
+	video  *ffmpeg
+	logger *slog.Logger
+	// queue renders thumbnails on ingest, nil to render them while files
+	// are stored
+	queue *queue.Queue
+
+	// mu serializes updates of the segment indexes of streams
+	mu sync.Mutex
+}
+
+// New creates a pipeline keeping thumbnails in store
+func New(cfg Config, store Store, logger *slog.Logger) *Pipeline {
+	if len(cfg.Sizes) == 0 {
+		cfg.Sizes = DefaultSizes
+	}
+	cfg.Sizes = slices.Sorted(slices.Values(cfg.Sizes))
+	if cfg.Quality <= 0 || cfg.Quality > 100 {
+		cfg.Quality = DefaultQuality
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if len(cfg.Profiles) == 0 {
+		cfg.Profiles = DefaultProfiles
+	}
+	if cfg.SegmentSeconds <= 0 {
+		cfg.SegmentSeconds = DefaultSegmentSeconds
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	p := &Pipeline{cfg: cfg, store: store, logger: logger}
+	if cfg.FFmpeg != "" {
+		p.video = &ffmpeg{bin: cfg.FFmpeg, prober: cfg.FFprobe, timeout: cfg.Timeout}
+	}
+	return p
+}
+
+// Sizes returns the thumbnail sizes the pipeline makes
+func (p *Pipeline) Sizes() []int { return slices.Clone(p.cfg.Sizes) }
+
+// DerivedKey is the key the thumbnail of key at size is stored under
+func DerivedKey(key string, size int) string {
+	return fmt.Sprintf("%s%s/%d.jpg", DerivedPrefix, key, size)
+}
+
+// Kind returns the kind of media of a content type, sniffing data when
+// the type is missing or generic; "" for anything else
+func Kind(contentType string, data []byte) string {
+	if contentType == "" || strings.HasPrefix(contentType, "application/octet-stream") {
+		contentType = http.DetectContentType(data)
+	}
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		return KindImage
+	case strings.HasPrefix(contentType, "video/"):
+		return KindVideo
+	case strings.HasPrefix(contentType, "audio/"):
+		return KindAudio
+	}
+	return ""
+}
+
+// Probe reads the dimensions, and duration of videos and audio, of a media
+// file
+func (p *Pipeline) Probe(ctx context.Context, contentType string, data []byte) (Info, error) {
+	switch Kind(contentType, data) {
+	case KindImage:
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return Info{}, fmt.Errorf("%w: %v", ErrUnsupported, err)
+		}
+		return Info{Kind: KindImage, Width: cfg.Width, Height: cfg.Height}, nil
+	case KindVideo, KindAudio:
+		if p.video == nil {
+			return Info{}, fmt.Errorf("%w: videos and audio need ffmpeg", ErrUnsupported)
+		}
+		return p.video.probe(ctx, data)
+	}
+	return Info{}, ErrUnsupported
+}
+
+// Ingest probes a file being stored and, with OnIngest, renders its
+// thumbnails. It returns the metadata entries to record, nil for files
+// that are not media.
+func (p *Pipeline) Ingest(ctx context.Context, key, contentType string, data []byte) map[string]string {
+	if err := p.Invalidate(ctx, key); err != nil {
+		p.logger.Warn("failed to delete stale thumbnails", "key", key, "error", err)
+	}
+	info, err := p.Probe(ctx, contentType, data)
+	if err != nil {
+		if !errors.Is(err, ErrUnsupported) || Kind(contentType, data) != "" {
+			p.logger.Warn("failed to probe media", "key", key, "error", err)
+		}
+		return nil
+	}
+	if p.cfg.OnIngest && p.queue != nil {
+		if _, err := p.queue.Enqueue(ThumbnailJob, thumbnailJob{Key: key}, queue.EnqueueOptions{Key: key}); err != nil {
+			p.logger.Warn("failed to queue thumbnails", "key", key, "error", err)
+		}
+	} else if p.cfg.OnIngest {
+		for _, size := range p.cfg.Sizes {
+			if _, err := p.render(ctx, key, contentType, size, data); err != nil {
+				p.logger.Warn("failed to render thumbnail", "key", key, "size", size, "error", err)
+				break
+			}
+		}
+	}
+	return info.Metadata()
+}
+
+// Loader reads the content type and content of a stored file, failing
+// with an error wrapping os.ErrNotExist once it is deleted
+type Loader func(ctx context.Context, key string) (contentType string, data []byte, err error)
+
+// thumbnailJob is the payload of thumbnail jobs
+type thumbnailJob struct {
+	Key string `json:"key"`
+}
+
+// Defer renders the thumbnails made on ingest in jobs of q, which read
+// files with load, instead of while files are stored
+func (p *Pipeline) Defer(q *queue.Queue, load Loader) error {
+	err := q.Handle(ThumbnailJob, func(ctx context.Context, payload json.RawMessage) error {
+		var job thumbnailJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return fmt.Errorf("%w: %v", queue.ErrPermanent, err)
+		}
+		return p.renderMissing(ctx, job.Key, load)
+	})
+	if err != nil {
+		return err
+	}
+	p.queue = q
+	return nil
+}
+
+// renderMissing renders the thumbnails of key that are not stored,
+// skipping files deleted since they were queued
+func (p *Pipeline) renderMissing(ctx context.Context, key string, load Loader) error {
+	contentType, data, err := load(ctx, key)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, size := range p.cfg.Sizes {
+		if p.store.Has(DerivedKey(key, size)) {
+			continue
+		}
+		if _, err := p.render(ctx, key, contentType, size, data); errors.Is(err, ErrUnsupported) {
+			return fmt.Errorf("%w: %w", queue.ErrPermanent, err)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Thumbnail returns the JPEG thumbnail of key at size, rendering it from
+// the content load returns unless it is stored
+func (p *Pipeline) Thumbnail(ctx context.Context, key, contentType string, size int, load func() ([]byte, error)) ([]byte, error) {
+	if !slices.Contains(p.cfg.Sizes, size) {
+		return nil, fmt.Errorf("%w: %d (sizes are %v)", ErrSize, size, p.cfg.Sizes)
+	}
+	derived := DerivedKey(key, size)
+	if p.store.Has(derived) {
+		if thumb, err := p.store.Get(ctx, derived); err == nil {
+			return thumb, nil
+		}
+	}
+	data, err := load()
+	if err != nil {
+		return nil, err
+	}
+	return p.render(ctx, key, contentType, size, data)
+}
+
+// Invalidate deletes the thumbnails and stream segments of key, e.g. once
+// it is overwritten
+func (p *Pipeline) Invalidate(ctx context.Context, key string) error {
+	var errs []error
+	for _, size := range p.cfg.Sizes {
+		derived := DerivedKey(key, size)
+		if p.store.Has(derived) {
+			errs = append(errs, p.store.Delete(ctx, derived))
+		}
+	}
+	errs = append(errs, p.dropSegments(ctx, key))
+	return errors.Join(errs...)
+}
+
+// render makes and stores the thumbnail of key at size
+func (p *Pipeline) render(ctx context.Context, key, contentType string, size int, data []byte) ([]byte, error) {
+	var src image.Image
+	var err error
+	switch Kind(contentType, data) {
+	case KindImage:
+		src, _, err = image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+		}
+	case KindVideo:
+		if p.video == nil {
+			return nil, fmt.Errorf("%w: videos need ffmpeg", ErrUnsupported)
+		}
+		if src, err = p.video.poster(ctx, data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupported
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flatten(Fit(src, size)), &jpeg.Options{Quality: p.cfg.Quality}); err != nil {
+		return nil, err
+	}
+	thumb := buf.Bytes()
+	if err := p.store.Put(ctx, DerivedKey(key, size), thumb); err != nil {
+		p.logger.Warn("failed to store thumbnail", "key", key, "size", size, "error", err)
+	}
+	return thumb, nil
+}
+
+// flatten draws img over white, as JPEG has no transparency
+func flatten(img image.Image) image.Image {
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
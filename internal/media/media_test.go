@@ -0,0 +1,273 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/queue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore is a Store in memory
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemStore() *memStore { return &memStore{objects: make(map[string][]byte)} }
+
+func (s *memStore) Has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.objects[key]
+	return ok
+}
+
+func (s *memStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return data, nil
+}
+
+func (s *memStore) Put(ctx context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	return nil
+}
+
+func (s *memStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestFit(t *testing.T) {
+	wide := Fit(image.NewRGBA(image.Rect(0, 0, 400, 100)), 64)
+	assert.Equal(t, image.Rect(0, 0, 64, 16), wide.Bounds())
+	tall := Fit(image.NewRGBA(image.Rect(0, 0, 100, 1000)), 50)
+	assert.Equal(t, image.Rect(0, 0, 5, 50), tall.Bounds())
+
+	small := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	assert.Same(t, small, Fit(small, 64), "images are never scaled up")
+
+	// Averages keep a uniform color
+	red := image.NewUniform(color.RGBA{R: 255, A: 255})
+	src := image.NewRGBA(image.Rect(0, 0, 30, 30))
+	for y := range 30 {
+		for x := range 30 {
+			src.Set(x, y, red.C)
+		}
+	}
+	r, g, b, a := Fit(src, 7).At(3, 3).RGBA()
+	assert.Equal(t, []uint32{0xffff, 0, 0, 0xffff}, []uint32{r, g, b, a})
+}
+
+func TestProbeAndIngest(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	p := New(Config{Sizes: []int{32, 16}, OnIngest: true}, store, nil)
+	assert.Equal(t, []int{16, 32}, p.Sizes())
+
+	data := testPNG(t, 120, 60)
+	meta := p.Ingest(ctx, "photos/cat.png", "", data)
+	assert.Equal(t, map[string]string{MetaKind: KindImage, MetaWidth: "120", MetaHeight: "60"}, meta)
+	require.True(t, store.Has(DerivedKey("photos/cat.png", 16)))
+	require.True(t, store.Has(DerivedKey("photos/cat.png", 32)))
+
+	thumb, err := jpeg.Decode(bytes.NewReader(store.objects[DerivedKey("photos/cat.png", 32)]))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 32, 16), thumb.Bounds())
+
+	assert.Nil(t, p.Ingest(ctx, "notes.txt", "text/plain", []byte("hello")))
+	assert.Len(t, store.objects, 2)
+
+	require.NoError(t, p.Invalidate(ctx, "photos/cat.png"))
+	assert.Empty(t, store.objects)
+}
+
+func TestDeferredThumbnails(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	p := New(Config{Sizes: []int{16, 32}, OnIngest: true}, store, nil)
+	files := map[string][]byte{"photos/cat.png": testPNG(t, 120, 60), "notes.png": []byte("not a png")}
+	q := queue.New(queue.Options{})
+	require.NoError(t, p.Defer(q, func(ctx context.Context, key string) (string, []byte, error) {
+		data, ok := files[key]
+		if !ok {
+			return "", nil, os.ErrNotExist
+		}
+		return "image/png", data, nil
+	}))
+
+	// Ingesting queues the thumbnails rather than rendering them
+	require.NotNil(t, p.Ingest(ctx, "photos/cat.png", "", files["photos/cat.png"]))
+	require.NotNil(t, p.Ingest(ctx, "photos/gone.png", "", testPNG(t, 10, 10)))
+	assert.Empty(t, store.objects)
+	_, err := q.Enqueue(ThumbnailJob, thumbnailJob{Key: "notes.png"}, queue.EnqueueOptions{})
+	require.NoError(t, err)
+
+	q.Start()
+	defer q.Stop()
+	require.Eventually(t, func() bool {
+		st := q.Stats()
+		return st.Pending == 0 && st.Running == 0
+	}, 5*time.Second, time.Millisecond)
+	assert.True(t, store.Has(DerivedKey("photos/cat.png", 16)))
+	assert.True(t, store.Has(DerivedKey("photos/cat.png", 32)))
+	assert.False(t, store.Has(DerivedKey("photos/gone.png", 16)))
+	dead := q.List(queue.Dead, ThumbnailJob)
+	require.Len(t, dead, 1)
+	assert.Contains(t, dead[0].LastError, "unsupported content")
+}
+
+func TestThumbnail(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	p := New(Config{}, store, nil)
+	data := testPNG(t, 300, 300)
+
+	loads := 0
+	load := func() ([]byte, error) { loads++; return data, nil }
+	for range 2 {
+		thumb, err := p.Thumbnail(ctx, "a.png", "image/png", 256, load)
+		require.NoError(t, err)
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(thumb))
+		require.NoError(t, err)
+		assert.Equal(t, 256, cfg.Width)
+	}
+	assert.Equal(t, 1, loads, "a rendered thumbnail is stored")
+
+	_, err := p.Thumbnail(ctx, "a.png", "image/png", 100, load)
+	assert.ErrorIs(t, err, ErrSize)
+	_, err = p.Thumbnail(ctx, "a.txt", "text/plain", 64, func() ([]byte, error) { return []byte("text"), nil })
+	assert.ErrorIs(t, err, ErrUnsupported)
+	_, err = p.Thumbnail(ctx, "a.mp4", "video/mp4", 64, func() ([]byte, error) { return []byte("video"), nil })
+	assert.ErrorIs(t, err, ErrUnsupported, "videos need ffmpeg")
+}
+
+func TestParseProbe(t *testing.T) {
+	info, err := parseProbe([]byte(`{"streams":[{"codec_type":"audio"},{"codec_type":"video","width":1920,"height":1080}],"format":{"duration":"12.480000"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, Info{Kind: KindVideo, Width: 1920, Height: 1080, Duration: 12480 * time.Millisecond}, info)
+	assert.Equal(t, "12.480", info.Metadata()[MetaDuration])
+
+	// Cover art does not make audio a video
+	info, err = parseProbe([]byte(`{"streams":[{"codec_type":"video","width":500,"height":500,"disposition":{"attached_pic":1}},{"codec_type":"audio"}],"format":{"duration":"200.5"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, Info{Kind: KindAudio, Duration: 200500 * time.Millisecond}, info)
+	assert.Equal(t, map[string]string{MetaKind: KindAudio, MetaDuration: "200.500"}, info.Metadata())
+
+	_, err = parseProbe([]byte(`{"streams":[{"codec_type":"data"}],"format":{}}`))
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestParseProfiles(t *testing.T) {
+	profiles, err := ParseProfiles("480p:480:1200k:96k, hq:0:8M:")
+	require.NoError(t, err)
+	assert.Equal(t, []Profile{
+		{Name: "480p", Height: 480, VideoBitrate: "1200k", AudioBitrate: "96k"},
+		{Name: "hq", VideoBitrate: "8M"},
+	}, profiles)
+	assert.Equal(t, 8_000_000, profiles[1].bandwidth(KindVideo))
+
+	for _, bad := range []string{"480p:480:1200k", "a/b:480:1k:1k", "x:-1:1k:1k", "x:480:fast:1k"} {
+		_, err := ParseProfiles(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestPlaylists(t *testing.T) {
+	p := New(Config{FFmpeg: "ffmpeg", SegmentSeconds: 4}, newMemStore(), nil)
+	video := Info{Kind: KindVideo, Width: 1280, Height: 720, Duration: 10 * time.Second}
+
+	master, err := p.MasterPlaylist(video)
+	require.NoError(t, err)
+	assert.Equal(t, "#EXTM3U\n#EXT-X-VERSION:3\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=896000,RESOLUTION=640x360,NAME=\"360p\"\n360p/index.m3u8\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=2928000,RESOLUTION=1280x720,NAME=\"720p\"\n720p/index.m3u8\n", string(master))
+
+	// Profiles taller than the video are left out, but one is always offered
+	small, err := p.MasterPlaylist(Info{Kind: KindVideo, Width: 320, Height: 240, Duration: time.Second})
+	require.NoError(t, err)
+	assert.Contains(t, string(small), "RESOLUTION=320x240,NAME=\"360p\"")
+	assert.NotContains(t, string(small), "720p")
+
+	playlist, err := p.MediaPlaylist(video, "720p")
+	require.NoError(t, err)
+	assert.Equal(t, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n"+
+		"#EXTINF:4.000,\n0.ts\n#EXTINF:4.000,\n1.ts\n#EXTINF:2.000,\n2.ts\n#EXT-X-ENDLIST\n", string(playlist))
+
+	_, err = p.MediaPlaylist(video, "1080p")
+	assert.ErrorIs(t, err, ErrProfile)
+	_, err = p.MasterPlaylist(Info{Kind: KindImage, Width: 10, Height: 10})
+	assert.ErrorIs(t, err, ErrUnsupported)
+	_, err = New(Config{}, newMemStore(), nil).MasterPlaylist(video)
+	assert.ErrorIs(t, err, ErrUnsupported, "streaming needs ffmpeg")
+}
+
+func TestSegment(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	// The fake ffmpeg prints its arguments as the segment
+	bin := filepath.Join(t.TempDir(), "ffmpeg")
+	require.NoError(t, os.WriteFile(bin, []byte("#!/bin/sh\necho \"$@\"\n"), 0o755))
+
+	ctx := context.Background()
+	store := newMemStore()
+	p := New(Config{FFmpeg: bin}, store, nil)
+	info := Info{Kind: KindVideo, Width: 1920, Height: 1080, Duration: 15 * time.Second}
+	loads := 0
+	load := func() ([]byte, error) { loads++; return []byte("video"), nil }
+
+	for range 2 {
+		segment, err := p.Segment(ctx, "movies/a.mp4", info, "360p", 1, load)
+		require.NoError(t, err)
+		assert.Contains(t, string(segment), "-ss 6 -i ")
+		assert.Contains(t, string(segment), "-vf scale=-2:360 -b:v 800k -b:a 96k -output_ts_offset 6 -f mpegts pipe:1")
+	}
+	assert.Equal(t, 1, loads, "a transcoded segment is stored")
+	assert.True(t, store.Has(SegmentKey("movies/a.mp4", "360p", 1)))
+
+	_, err := p.Segment(ctx, "movies/a.mp4", info, "360p", 3, load)
+	assert.ErrorIs(t, err, ErrSegment)
+
+	audio, err := p.Segment(ctx, "songs/b.mp3", Info{Kind: KindAudio, Duration: time.Minute}, "720p", 0, load)
+	require.NoError(t, err)
+	assert.Contains(t, string(audio), "-vn -c:a aac -b:a 128k")
+
+	require.NoError(t, p.Invalidate(ctx, "movies/a.mp4"))
+	assert.False(t, store.Has(SegmentKey("movies/a.mp4", "360p", 1)))
+	assert.Len(t, store.objects, 2, "other files keep their segments")
+}
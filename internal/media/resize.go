@@ -0,0 +1,49 @@
+package media
+
+import (
+	"image"
+	"image/color"
+)
+
+// Fit scales img down so its longest edge is size pixels, keeping its
+// aspect ratio. Each pixel averages the source pixels it covers. Images
+// already within size are returned as they are.
+func Fit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if size <= 0 || (w <= size && h <= size) {
+		return img
+	}
+	dw, dh := size, size
+	if w >= h {
+		dh = max(1, h*size/w)
+	} else {
+		dw = max(1, w*size/h)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range dh {
+		y0 := b.Min.Y + y*h/dh
+		y1 := max(b.Min.Y+(y+1)*h/dh, y0+1)
+		for x := range dw {
+			x0 := b.Min.X + x*w/dw
+			x1 := max(b.Min.X+(x+1)*w/dw, x0+1)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(bl / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
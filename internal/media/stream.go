@@ -0,0 +1,349 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// StreamPrefix is the namespace stream segments are stored under
+	StreamPrefix = ".streams/"
+	// DefaultSegmentSeconds is the length of stream segments
+	DefaultSegmentSeconds = 6
+
+	// PlaylistType is the content type of HLS playlists
+	PlaylistType = "application/vnd.apple.mpegurl"
+	// SegmentType is the content type of stream segments
+	SegmentType = "video/mp2t"
+)
+
+var (
+	// ErrProfile is returned for streams in profiles the pipeline does not
+	// transcode to
+	ErrProfile = errors.New("media: unknown stream profile")
+	// ErrSegment is returned for segments past the end of a stream
+	ErrSegment = errors.New("media: no such segment")
+)
+
+// Profile is a rendition videos and audio are transcoded to for streaming
+type Profile struct {
+	// Name identifies the profile in stream URLs
+	Name string `yaml:"name" json:"name"`
+	// Height of the video in pixels; the source's when zero. Profiles
+	// taller than a video are not offered for it.
+	Height int `yaml:"height" json:"height"`
+	// Bitrates such as 2800k handed to the H.264 and AAC encoders
+	VideoBitrate string `yaml:"video_bitrate" json:"video_bitrate"`
+	AudioBitrate string `yaml:"audio_bitrate" json:"audio_bitrate"`
+	// Args replace the encoder arguments given to ffmpeg between its input
+	// and its MPEG-TS output, e.g. to use a hardware encoder
+	Args []string `yaml:"args" json:"args"`
+}
+
+// DefaultProfiles are the renditions streams are offered in
+var DefaultProfiles = []Profile{
+	{Name: "360p", Height: 360, VideoBitrate: "800k", AudioBitrate: "96k"},
+	{Name: "720p", Height: 720, VideoBitrate: "2800k", AudioBitrate: "128k"},
+}
+
+var profileName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ParseProfiles parses a comma-separated list of profiles written as
+// name:height:video-bitrate:audio-bitrate, e.g. 720p:720:2800k:128k
+func ParseProfiles(value string) ([]Profile, error) {
+	var profiles []Profile
+	for item := range strings.SplitSeq(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		fields := strings.Split(item, ":")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid profile %q: want name:height:video-bitrate:audio-bitrate", item)
+		}
+		height, err := strconv.Atoi(fields[1])
+		if err != nil || height < 0 {
+			return nil, fmt.Errorf("invalid height in profile %q", item)
+		}
+		p := Profile{Name: fields[0], Height: height, VideoBitrate: fields[2], AudioBitrate: fields[3]}
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(profiles, func(q Profile) bool { return q.Name == p.Name }) {
+			return nil, fmt.Errorf("duplicate profile %q", p.Name)
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+// Validate checks that p can be transcoded to and named in URLs
+func (p Profile) Validate() error {
+	if !profileName.MatchString(p.Name) {
+		return fmt.Errorf("invalid profile name %q: use letters, digits, - and _", p.Name)
+	}
+	if p.Height < 0 {
+		return fmt.Errorf("profile %s: height cannot be negative", p.Name)
+	}
+	for _, rate := range []string{p.VideoBitrate, p.AudioBitrate} {
+		if _, err := parseBitrate(rate); err != nil {
+			return fmt.Errorf("profile %s: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// bandwidth is the peak bits per second of p, as HLS playlists state it
+func (p Profile) bandwidth(kind string) int {
+	video, _ := parseBitrate(p.VideoBitrate)
+	audio, _ := parseBitrate(p.AudioBitrate)
+	if kind == KindAudio {
+		video = 0
+	}
+	if video+audio == 0 {
+		// Unknown for custom encoder arguments
+		return 2_000_000
+	}
+	return video + audio
+}
+
+// height returns the height p transcodes a video of source pixels to;
+// videos are never scaled up
+func (p Profile) height(source int) int {
+	if p.Height == 0 || source == 0 {
+		return source
+	}
+	return min(p.Height, source)
+}
+
+// parseBitrate parses bitrates such as 800k or 2M; "" is zero
+func parseBitrate(rate string) (int, error) {
+	if rate == "" {
+		return 0, nil
+	}
+	scale := 1
+	switch rate[len(rate)-1] {
+	case 'k', 'K':
+		scale, rate = 1_000, rate[:len(rate)-1]
+	case 'm', 'M':
+		scale, rate = 1_000_000, rate[:len(rate)-1]
+	}
+	n, err := strconv.Atoi(rate)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid bitrate %q", rate)
+	}
+	return n * scale, nil
+}
+
+// SegmentKey is the key segment n of key in profile is stored under
+func SegmentKey(key, profile string, n int) string {
+	return fmt.Sprintf("%s%s/%s/%05d.ts", StreamPrefix, key, profile, n)
+}
+
+// segmentIndexKey is the key listing the stored segments of key, so they
+// can be deleted with it
+func segmentIndexKey(key string) string {
+	return StreamPrefix + key + "/segments"
+}
+
+// InfoOf reads the media information recorded in the metadata of a file
+func InfoOf(meta map[string]string) (Info, bool) {
+	info := Info{Kind: meta[MetaKind]}
+	if info.Kind == "" {
+		return Info{}, false
+	}
+	info.Width, _ = strconv.Atoi(meta[MetaWidth])
+	info.Height, _ = strconv.Atoi(meta[MetaHeight])
+	if seconds, err := strconv.ParseFloat(meta[MetaDuration], 64); err == nil {
+		info.Duration = time.Duration(seconds * float64(time.Second))
+	}
+	return info, true
+}
+
+// Profiles returns the profiles the pipeline streams in
+func (p *Pipeline) Profiles() []Profile { return slices.Clone(p.cfg.Profiles) }
+
+// streamable checks that info describes media the pipeline can stream
+func (p *Pipeline) streamable(info Info) error {
+	if info.Kind != KindVideo && info.Kind != KindAudio {
+		return fmt.Errorf("%w: only videos and audio are streamed", ErrUnsupported)
+	}
+	if p.video == nil {
+		return fmt.Errorf("%w: streaming needs ffmpeg", ErrUnsupported)
+	}
+	if info.Duration <= 0 {
+		return fmt.Errorf("%w: unknown duration", ErrUnsupported)
+	}
+	return nil
+}
+
+// offered returns the profiles a stream is offered in: those no taller
+// than a video, or the shortest when all are
+func (p *Pipeline) offered(info Info) []Profile {
+	if info.Kind != KindVideo {
+		return p.cfg.Profiles
+	}
+	var fit []Profile
+	shortest := p.cfg.Profiles[0]
+	for _, profile := range p.cfg.Profiles {
+		if profile.Height <= info.Height {
+			fit = append(fit, profile)
+		}
+		if profile.Height < shortest.Height {
+			shortest = profile
+		}
+	}
+	if len(fit) == 0 {
+		return []Profile{shortest}
+	}
+	return fit
+}
+
+// profile returns the profile named name that info is offered in
+func (p *Pipeline) profile(info Info, name string) (Profile, error) {
+	for _, profile := range p.offered(info) {
+		if profile.Name == name {
+			return profile, nil
+		}
+	}
+	return Profile{}, fmt.Errorf("%w: %s", ErrProfile, name)
+}
+
+// segments returns the number of segments of a stream
+func (p *Pipeline) segments(info Info) int {
+	return int(math.Ceil(info.Duration.Seconds() / float64(p.cfg.SegmentSeconds)))
+}
+
+// MasterPlaylist returns the HLS playlist listing the profiles a file is
+// streamed in, each at <profile>/index.m3u8
+func (p *Pipeline) MasterPlaylist(info Info) ([]byte, error) {
+	if err := p.streamable(info); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, profile := range p.offered(info) {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d", profile.bandwidth(info.Kind))
+		if info.Kind == KindVideo && info.Height > 0 {
+			height := profile.height(info.Height)
+			// Encoders want even dimensions
+			width := int(math.Round(float64(info.Width)*float64(height)/float64(info.Height)/2)) * 2
+			fmt.Fprintf(&b, ",RESOLUTION=%dx%d", width, height)
+		}
+		fmt.Fprintf(&b, ",NAME=%q\n%s/index.m3u8\n", profile.Name, profile.Name)
+	}
+	return []byte(b.String()), nil
+}
+
+// MediaPlaylist returns the HLS playlist of the segments of a file in
+// profile, each at <n>.ts
+func (p *Pipeline) MediaPlaylist(info Info, profile string) ([]byte, error) {
+	if err := p.streamable(info); err != nil {
+		return nil, err
+	}
+	if _, err := p.profile(info, profile); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n", p.cfg.SegmentSeconds)
+	n := p.segments(info)
+	for i := range n {
+		length := float64(p.cfg.SegmentSeconds)
+		if i == n-1 {
+			length = info.Duration.Seconds() - float64(i*p.cfg.SegmentSeconds)
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%d.ts\n", length, i)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return []byte(b.String()), nil
+}
+
+// Segment returns segment n of a file in profile as MPEG-TS, transcoding
+// it from the content load returns unless it is stored
+func (p *Pipeline) Segment(ctx context.Context, key string, info Info, profile string, n int, load func() ([]byte, error)) ([]byte, error) {
+	if err := p.streamable(info); err != nil {
+		return nil, err
+	}
+	prof, err := p.profile(info, profile)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n >= p.segments(info) {
+		return nil, fmt.Errorf("%w: %d", ErrSegment, n)
+	}
+	derived := SegmentKey(key, profile, n)
+	if p.store.Has(derived) {
+		if segment, err := p.store.Get(ctx, derived); err == nil {
+			return segment, nil
+		}
+	}
+	data, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	start := n * p.cfg.SegmentSeconds
+	segment, err := p.video.segment(ctx, data, info, prof, start, p.cfg.SegmentSeconds)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.storeSegment(ctx, key, derived, segment); err != nil {
+		p.logger.Warn("failed to store stream segment", "key", key, "profile", profile, "segment", n, "error", err)
+	}
+	return segment, nil
+}
+
+// storeSegment stores a segment and lists it in the index of key
+func (p *Pipeline) storeSegment(ctx context.Context, key, derived string, segment []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	index, err := p.segmentIndex(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := p.store.Put(ctx, derived, segment); err != nil {
+		return err
+	}
+	if slices.Contains(index, derived) {
+		return nil
+	}
+	index = append(index, derived)
+	return p.store.Put(ctx, segmentIndexKey(key), []byte(strings.Join(index, "\n")))
+}
+
+// dropSegments deletes the stored segments of key and their index
+func (p *Pipeline) dropSegments(ctx context.Context, key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	index, err := p.segmentIndex(ctx, key)
+	if err != nil || len(index) == 0 {
+		return err
+	}
+	var errs []error
+	for _, derived := range index {
+		if p.store.Has(derived) {
+			errs = append(errs, p.store.Delete(ctx, derived))
+		}
+	}
+	errs = append(errs, p.store.Delete(ctx, segmentIndexKey(key)))
+	return errors.Join(errs...)
+}
+
+// segmentIndex returns the keys of the stored segments of key
+func (p *Pipeline) segmentIndex(ctx context.Context, key string) ([]string, error) {
+	if !p.store.Has(segmentIndexKey(key)) {
+		return nil, nil
+	}
+	data, err := p.store.Get(ctx, segmentIndexKey(key))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
@@ -0,0 +1,178 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ffmpeg reads videos with the ffmpeg and ffprobe binaries. Videos are
+// written to a temporary file first, as containers such as MP4 cannot be
+// read from a pipe.
+type ffmpeg struct {
+	bin     string
+	prober  string
+	timeout time.Duration
+}
+
+// probeBin returns ffprobe, by default next to ffmpeg
+func (f *ffmpeg) probeBin() string {
+	if f.prober != "" {
+		return f.prober
+	}
+	if dir := filepath.Dir(f.bin); dir != "." {
+		return filepath.Join(dir, "ffprobe")
+	}
+	return "ffprobe"
+}
+
+// probe reads the dimensions and duration of a video, or the duration of
+// audio
+func (f *ffmpeg) probe(ctx context.Context, data []byte) (Info, error) {
+	out, err := f.run(ctx, data, f.probeBin(), "-v", "error", "-print_format", "json", "-show_format", "-show_streams")
+	if err != nil {
+		return Info{}, err
+	}
+	return parseProbe(out)
+}
+
+// probeOutput is the part of ffprobe's JSON output that is read
+type probeOutput struct {
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+		// Cover art of audio files is a video stream of one picture
+		Disposition struct {
+			AttachedPic int `json:"attached_pic"`
+		} `json:"disposition"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+func parseProbe(out []byte) (Info, error) {
+	var p probeOutput
+	if err := json.Unmarshal(out, &p); err != nil {
+		return Info{}, fmt.Errorf("media: invalid ffprobe output: %w", err)
+	}
+	var info Info
+	for _, s := range p.Streams {
+		switch {
+		case s.CodecType == "video" && s.Width > 0 && s.Disposition.AttachedPic == 0 && info.Kind != KindVideo:
+			info = Info{Kind: KindVideo, Width: s.Width, Height: s.Height}
+		case s.CodecType == "audio" && info.Kind == "":
+			info.Kind = KindAudio
+		}
+	}
+	if info.Kind == "" {
+		return Info{}, fmt.Errorf("%w: no video or audio stream", ErrUnsupported)
+	}
+	if seconds, err := strconv.ParseFloat(p.Format.Duration, 64); err == nil {
+		info.Duration = time.Duration(seconds * float64(time.Second))
+	}
+	return info, nil
+}
+
+// poster grabs the frame one second into a video, or its first frame for
+// shorter videos
+func (f *ffmpeg) poster(ctx context.Context, data []byte) (image.Image, error) {
+	var last error
+	for _, at := range []string{"1", "0"} {
+		out, err := f.run(ctx, data, f.bin, "-v", "error", "-ss", at, "-i", "{}", "-frames:v", "1", "-f", "image2pipe", "-c:v", "png", "pipe:1")
+		if err != nil {
+			last = err
+			continue
+		}
+		if len(out) == 0 {
+			continue
+		}
+		return png.Decode(bytes.NewReader(out))
+	}
+	if last == nil {
+		last = fmt.Errorf("%w: no frame", ErrUnsupported)
+	}
+	return nil, last
+}
+
+// segment transcodes the seconds of data from start to MPEG-TS in
+// profile. Timestamps are offset to start, so players can join segments
+// transcoded separately.
+func (f *ffmpeg) segment(ctx context.Context, data []byte, info Info, profile Profile, start, length int) ([]byte, error) {
+	args := []string{"-v", "error", "-ss", strconv.Itoa(start), "-i", "{}", "-t", strconv.Itoa(length)}
+	switch {
+	case len(profile.Args) > 0:
+		args = append(args, profile.Args...)
+	case info.Kind == KindAudio:
+		args = append(args, "-vn", "-c:a", "aac")
+		if profile.AudioBitrate != "" {
+			args = append(args, "-b:a", profile.AudioBitrate)
+		}
+	default:
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-c:a", "aac")
+		if height := profile.height(info.Height); height != info.Height {
+			args = append(args, "-vf", "scale=-2:"+strconv.Itoa(height))
+		}
+		if profile.VideoBitrate != "" {
+			args = append(args, "-b:v", profile.VideoBitrate)
+		}
+		if profile.AudioBitrate != "" {
+			args = append(args, "-b:a", profile.AudioBitrate)
+		}
+	}
+	args = append(args, "-output_ts_offset", strconv.Itoa(start), "-f", "mpegts", "pipe:1")
+	out, err := f.run(ctx, data, f.bin, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("media: %s: empty segment", filepath.Base(f.bin))
+	}
+	return out, nil
+}
+
+// run runs bin on data written to a temporary file. The file's path
+// replaces the argument "{}", or is appended when there is none.
+func (f *ffmpeg) run(ctx context.Context, data []byte, bin string, args ...string) ([]byte, error) {
+	tmp, err := os.CreateTemp("", "peervault-media-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	replaced := false
+	for i, arg := range args {
+		if arg == "{}" {
+			args[i], replaced = tmp.Name(), true
+		}
+	}
+	if !replaced {
+		args = append(args, tmp.Name())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("media: %s: %w: %s", filepath.Base(bin), err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return stdout.Bytes(), nil
+}
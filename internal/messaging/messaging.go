@@ -0,0 +1,646 @@
+// Package messaging lets applications built on PeerVault message each
+// other over the peer-to-peer network. A message is addressed to a node and
+// a named channel on it, and applications on that node receive the
+// messages of the channel. Messages to nodes that are not connected wait in
+// the sender's outbox, and messages no application received yet wait in
+// the recipient's inbox, both until their TTL runs out. Recipients answer
+// with receipts, so senders learn whether a message was queued, delivered,
+// expired or rejected.
+package messaging
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTTL is how long messages sent without a TTL wait to be
+	// delivered
+	DefaultTTL = 24 * time.Hour
+	// MaxTTL bounds the TTL of messages
+	MaxTTL = 7 * 24 * time.Hour
+	// DefaultMaxQueue is how many messages wait in the outbox, and in the
+	// inbox, before new ones are refused
+	DefaultMaxQueue = 10000
+	// DefaultRetryInterval is how often messages waiting in the outbox are
+	// sent again
+	DefaultRetryInterval = 30 * time.Second
+	// MaxPayload is the largest payload in bytes
+	MaxPayload = 256 << 10
+
+	// retention is how long the status of a message is kept after it
+	// expired, so senders can still look it up
+	retention = time.Hour
+)
+
+var (
+	// ErrInvalid is returned for messages without a node, with a malformed
+	// channel name or with a payload over MaxPayload
+	ErrInvalid = errors.New("messaging: invalid message")
+	// ErrQueueFull is returned for messages sent while MaxQueue messages
+	// wait in the outbox
+	ErrQueueFull = errors.New("messaging: outbox full")
+	// ErrNotFound is returned for the status of messages this node did not
+	// send, or whose status is no longer kept
+	ErrNotFound = errors.New("messaging: message not found")
+	// ErrUnreachable is returned by transports for nodes that are not
+	// connected; their messages wait in the outbox
+	ErrUnreachable = errors.New("messaging: node unreachable")
+	// ErrDetached is returned for messages sent before the mailbox is
+	// attached to a node
+	ErrDetached = errors.New("messaging: mailbox not attached to a node")
+)
+
+// validChannel matches channel names, such as "orders" or "app/jobs.done"
+var validChannel = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]{0,127}$`)
+
+// Status is how far a message got
+type Status string
+
+const (
+	// Pending messages wait in the outbox for their node to be reached
+	Pending Status = "pending"
+	// Queued messages wait in the recipient's inbox for an application to
+	// receive them
+	Queued Status = "queued"
+	// Delivered messages were handed to an application of the recipient
+	Delivered Status = "delivered"
+	// Expired messages were not delivered before their TTL ran out
+	Expired Status = "expired"
+	// Rejected messages were refused by the recipient, such as when its
+	// inbox is full
+	Rejected Status = "rejected"
+)
+
+// Final reports whether the status of a message no longer changes
+func (s Status) Final() bool {
+	return s == Delivered || s == Expired || s == Rejected
+}
+
+// Message is an application message
+type Message struct {
+	ID string `json:"id"`
+	// From and To are the IDs of the sending and receiving nodes
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Channel string `json:"channel"`
+	// Payload is opaque to PeerVault
+	Payload   []byte    `json:"payload"`
+	SentAt    time.Time `json:"sent_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Receipt tells the sender of a message how far it got
+type Receipt struct {
+	MessageID string `json:"message_id"`
+	// Node is the ID of the recipient
+	Node    string    `json:"node"`
+	Channel string    `json:"channel"`
+	Status  Status    `json:"status"`
+	Time    time.Time `json:"time"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Delivery is the status of a message this node sent
+type Delivery struct {
+	ID        string    `json:"id"`
+	To        string    `json:"to"`
+	Channel   string    `json:"channel"`
+	Size      int       `json:"size"`
+	SentAt    time.Time `json:"sent_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Status    Status    `json:"status"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Transport carries messages and receipts to other nodes
+type Transport interface {
+	// SendMessage sends m to the node with the given ID, returning
+	// ErrUnreachable when it is not connected
+	SendMessage(node string, m Message) error
+	// SendReceipt sends r to the node with the given ID
+	SendReceipt(node string, r Receipt) error
+}
+
+// Options configures a mailbox
+type Options struct {
+	// Path persists the messages waiting in the outbox and inbox, and the
+	// status of those sent; empty keeps them in memory
+	Path string
+	// MaxQueue bounds the messages waiting in the outbox, and in the
+	// inbox; zero uses DefaultMaxQueue
+	MaxQueue int
+	// RetryInterval is how often waiting messages are sent again; zero
+	// uses DefaultRetryInterval
+	RetryInterval time.Duration
+}
+
+// sent is a message this node sent
+type sent struct {
+	Delivery
+	// Payload is kept until the recipient has the message
+	Payload []byte `json:"payload,omitempty"`
+}
+
+// received is a message this node received; its payload is dropped once
+// it is delivered
+type received struct {
+	Message
+	Status     Status    `json:"status"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// state is how the mailbox is persisted
+type state struct {
+	Sent     []*sent     `json:"sent"`
+	Received []*received `json:"received"`
+}
+
+// Mailbox sends the application messages of a node and holds those it
+// receives
+type Mailbox struct {
+	opts Options
+	now  func() time.Time
+
+	mu        sync.Mutex
+	node      string
+	transport Transport
+	sent      map[string]*sent
+	received  map[string]*received
+	// inbox holds the queued messages by channel, oldest first
+	inbox map[string][]*received
+	// pending and queued count the messages in the outbox and inbox
+	pending, queued int
+	// arrivals are closed when a message is queued on their channel
+	arrivals map[string]chan struct{}
+	receipts map[chan Receipt]struct{}
+	dirty    bool
+}
+
+// New creates a mailbox, which sends nothing until it is attached
+func New(opts Options) *Mailbox {
+	if opts.MaxQueue <= 0 {
+		opts.MaxQueue = DefaultMaxQueue
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = DefaultRetryInterval
+	}
+	return &Mailbox{
+		opts:     opts,
+		now:      time.Now,
+		sent:     make(map[string]*sent),
+		received: make(map[string]*received),
+		inbox:    make(map[string][]*received),
+		arrivals: make(map[string]chan struct{}),
+		receipts: make(map[chan Receipt]struct{}),
+	}
+}
+
+// Attach makes the mailbox that of the node with the given ID, sending
+// through t; without a transport messages only reach this node
+func (mb *Mailbox) Attach(node string, t Transport) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.node, mb.transport = node, t
+}
+
+// Node returns the ID of the node the mailbox is attached to
+func (mb *Mailbox) Node() string {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.node
+}
+
+// RetryInterval returns how often waiting messages are sent again
+func (mb *Mailbox) RetryInterval() time.Duration { return mb.opts.RetryInterval }
+
+// Send sends payload to a channel of a node, this one included. The message
+// waits in the outbox while the node is unreachable; ttl bounds how long
+// it waits there and in the node's inbox, zero using DefaultTTL. The
+// returned delivery is pending until a receipt arrives.
+func (mb *Mailbox) Send(to, channel string, payload []byte, ttl time.Duration) (Delivery, error) {
+	if to == "" {
+		return Delivery{}, fmt.Errorf("%w: no node", ErrInvalid)
+	}
+	if err := validate(channel, payload); err != nil {
+		return Delivery{}, err
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	id, err := newID()
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	mb.mu.Lock()
+	if mb.node == "" {
+		mb.mu.Unlock()
+		return Delivery{}, ErrDetached
+	}
+	if mb.pending >= mb.opts.MaxQueue {
+		mb.mu.Unlock()
+		return Delivery{}, ErrQueueFull
+	}
+	now := mb.now()
+	m := Message{
+		ID: id, From: mb.node, To: to, Channel: channel, Payload: bytes.Clone(payload),
+		SentAt: now, ExpiresAt: now.Add(min(ttl, MaxTTL)),
+	}
+	s := &sent{
+		Delivery: Delivery{
+			ID: id, To: to, Channel: channel, Size: len(payload), SentAt: m.SentAt, ExpiresAt: m.ExpiresAt,
+			Status: Pending, UpdatedAt: now,
+		},
+		Payload: m.Payload,
+	}
+	mb.sent[id] = s
+	mb.pending++
+	mb.dirty = true
+	local, t := to == mb.node, mb.transport
+	mb.mu.Unlock()
+
+	if local {
+		mb.HandleMessage(m)
+	} else {
+		mb.deliver(t, m)
+	}
+	return mb.Status(id)
+}
+
+// deliver sends a message; it stays in the outbox when that fails
+func (mb *Mailbox) deliver(t Transport, m Message) {
+	if t == nil {
+		return
+	}
+	if err := t.SendMessage(m.To, m); err != nil && !errors.Is(err, ErrUnreachable) {
+		slog.Debug("failed to send application message", "id", m.ID, "node", m.To, "error", err)
+	}
+}
+
+// HandleMessage queues a message sent to this node in the inbox of its
+// channel, and answers the sender with a receipt. Messages sent again,
+// because their receipt was lost, are answered without being queued twice.
+func (mb *Mailbox) HandleMessage(m Message) {
+	mb.mu.Lock()
+	now := mb.now()
+	r := Receipt{MessageID: m.ID, Node: mb.node, Channel: m.Channel, Time: now}
+	if prev, ok := mb.received[m.ID]; ok {
+		r.Status = prev.Status
+	} else if err := validate(m.Channel, m.Payload); err != nil || m.ID == "" || m.To != mb.node {
+		r.Status, r.Error = Rejected, "invalid message"
+	} else if !now.Before(m.ExpiresAt) {
+		r.Status = Expired
+	} else if mb.queued >= mb.opts.MaxQueue {
+		r.Status, r.Error = Rejected, "inbox full"
+	} else {
+		rec := &received{Message: m, Status: Queued, ReceivedAt: now}
+		mb.received[m.ID] = rec
+		mb.inbox[m.Channel] = append(mb.inbox[m.Channel], rec)
+		mb.queued++
+		mb.dirty = true
+		if ch, ok := mb.arrivals[m.Channel]; ok {
+			close(ch)
+			delete(mb.arrivals, m.Channel)
+		}
+		r.Status = Queued
+	}
+	t := mb.transport
+	mb.mu.Unlock()
+	mb.answer(t, m.From, r)
+}
+
+// answer sends a receipt to the sender of a message
+func (mb *Mailbox) answer(t Transport, from string, r Receipt) {
+	if from == r.Node {
+		mb.HandleReceipt(r)
+		return
+	}
+	if t == nil {
+		return
+	}
+	if err := t.SendReceipt(from, r); err != nil {
+		// The sender sends the message again and gets another receipt
+		slog.Debug("failed to send message receipt", "id", r.MessageID, "node", from, "error", err)
+	}
+}
+
+// Receive returns the oldest message queued on a channel, waiting for one
+// until ctx is done. Each message is received once, by whichever
+// application asks first, and its sender learns it was delivered.
+func (mb *Mailbox) Receive(ctx context.Context, channel string) (Message, error) {
+	if err := CheckChannel(channel); err != nil {
+		return Message{}, err
+	}
+	for {
+		mb.mu.Lock()
+		now := mb.now()
+		var receipts []Receipt
+		var senders []string
+		var m *Message
+		for q := mb.inbox[channel]; len(q) > 0 && m == nil; q = mb.inbox[channel] {
+			rec := q[0]
+			mb.dequeue(channel)
+			r := Receipt{MessageID: rec.ID, Node: mb.node, Channel: channel, Status: Delivered, Time: now}
+			if !now.Before(rec.ExpiresAt) {
+				r.Status = Expired
+			} else {
+				msg := rec.Message
+				m = &msg
+			}
+			rec.Status, rec.Payload = r.Status, nil
+			receipts = append(receipts, r)
+			senders = append(senders, rec.From)
+		}
+		var arrival chan struct{}
+		if m == nil {
+			arrival = mb.arrivals[channel]
+			if arrival == nil {
+				arrival = make(chan struct{})
+				mb.arrivals[channel] = arrival
+			}
+		}
+		t := mb.transport
+		mb.mu.Unlock()
+
+		for i, r := range receipts {
+			mb.answer(t, senders[i], r)
+		}
+		if m != nil {
+			return *m, nil
+		}
+		select {
+		case <-arrival:
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		}
+	}
+}
+
+// dequeue drops the oldest message of a channel's inbox; the caller holds
+// mb.mu
+func (mb *Mailbox) dequeue(channel string) {
+	q := mb.inbox[channel]
+	q[0] = nil
+	if len(q) == 1 {
+		delete(mb.inbox, channel)
+	} else {
+		mb.inbox[channel] = q[1:]
+	}
+	mb.queued--
+	mb.dirty = true
+}
+
+// HandleReceipt records how far a message this node sent got, and hands
+// the receipt to the subscribers of receipts. Receipts of unknown messages,
+// and those not advancing the status, are ignored.
+func (mb *Mailbox) HandleReceipt(r Receipt) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	s, ok := mb.sent[r.MessageID]
+	if !ok || !mb.advance(s, r) {
+		return
+	}
+	mb.publish(r)
+}
+
+// advance applies a receipt to a message, reporting whether it changed its
+// status; the caller holds mb.mu
+func (mb *Mailbox) advance(s *sent, r Receipt) bool {
+	switch {
+	case s.Status.Final(), r.Status == Pending, r.Status == s.Status:
+		return false
+	case r.Status != Queued && !r.Status.Final():
+		return false
+	}
+	if s.Status == Pending {
+		mb.pending--
+		s.Payload = nil
+	}
+	s.Status, s.UpdatedAt, s.Error = r.Status, r.Time, r.Error
+	mb.dirty = true
+	return true
+}
+
+// publish hands a receipt to its subscribers, dropping it for those that
+// fell behind; the caller holds mb.mu
+func (mb *Mailbox) publish(r Receipt) {
+	for ch := range mb.receipts {
+		select {
+		case ch <- r:
+		default:
+		}
+	}
+}
+
+// Receipts subscribes to the receipts of the messages this node sends, and
+// to their expiry in the outbox; stop ends the subscription
+func (mb *Mailbox) Receipts(buffer int) (<-chan Receipt, func()) {
+	ch := make(chan Receipt, buffer)
+	mb.mu.Lock()
+	mb.receipts[ch] = struct{}{}
+	mb.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			mb.mu.Lock()
+			delete(mb.receipts, ch)
+			mb.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Status returns the status of a message this node sent
+func (mb *Mailbox) Status(id string) (Delivery, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	s, ok := mb.sent[id]
+	if !ok {
+		return Delivery{}, ErrNotFound
+	}
+	return s.Delivery, nil
+}
+
+// Retry sends the messages waiting in the outbox for a node again, or
+// those for every node when node is empty. It also expires the messages
+// whose TTL ran out in the outbox and inbox, and forgets the status of
+// messages that expired a while ago.
+func (mb *Mailbox) Retry(node string) {
+	mb.mu.Lock()
+	now := mb.now()
+	var resend []Message
+	for id, s := range mb.sent {
+		switch {
+		case now.Sub(s.ExpiresAt) > retention:
+			if s.Status == Pending {
+				mb.pending--
+			}
+			delete(mb.sent, id)
+			mb.dirty = true
+		case s.Status != Pending:
+		case !now.Before(s.ExpiresAt):
+			r := Receipt{MessageID: id, Node: s.To, Channel: s.Channel, Status: Expired, Time: now, Error: "the node was not reached in time"}
+			mb.advance(s, r)
+			mb.publish(r)
+		case node == "" || s.To == node:
+			resend = append(resend, Message{
+				ID: id, From: mb.node, To: s.To, Channel: s.Channel, Payload: s.Payload,
+				SentAt: s.SentAt, ExpiresAt: s.ExpiresAt,
+			})
+		}
+	}
+
+	var receipts []Receipt
+	var senders []string
+	for channel, q := range mb.inbox {
+		for len(q) > 0 && !now.Before(q[0].ExpiresAt) {
+			rec := q[0]
+			mb.dequeue(channel)
+			rec.Status, rec.Payload = Expired, nil
+			receipts = append(receipts, Receipt{MessageID: rec.ID, Node: mb.node, Channel: channel, Status: Expired, Time: now})
+			senders = append(senders, rec.From)
+			q = mb.inbox[channel]
+		}
+	}
+	for id, rec := range mb.received {
+		if now.Sub(rec.ExpiresAt) > retention {
+			delete(mb.received, id)
+			mb.dirty = true
+		}
+	}
+	t := mb.transport
+	mb.mu.Unlock()
+
+	for i, r := range receipts {
+		mb.answer(t, senders[i], r)
+	}
+	if t == nil {
+		return
+	}
+	slices.SortFunc(resend, func(a, b Message) int {
+		return cmp.Or(a.SentAt.Compare(b.SentAt), cmp.Compare(a.ID, b.ID))
+	})
+	for _, m := range resend {
+		mb.deliver(t, m)
+	}
+}
+
+// Flush persists the mailbox when it changed since the last flush
+func (mb *Mailbox) Flush() error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if !mb.dirty || mb.opts.Path == "" {
+		return nil
+	}
+	st := state{Sent: make([]*sent, 0, len(mb.sent)), Received: make([]*received, 0, len(mb.received))}
+	for _, s := range mb.sent {
+		st.Sent = append(st.Sent, s)
+	}
+	for _, rec := range mb.received {
+		st.Received = append(st.Received, rec)
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(mb.opts.Path), 0700); err != nil {
+		return err
+	}
+	tmp := mb.opts.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, mb.opts.Path); err != nil {
+		return err
+	}
+	mb.dirty = false
+	return nil
+}
+
+// Load reads the persisted mailbox, adding its messages to those sent and
+// received so far
+func (mb *Mailbox) Load() error {
+	if mb.opts.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(mb.opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("messaging: corrupt mailbox %s: %w", mb.opts.Path, err)
+	}
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	for _, s := range st.Sent {
+		if _, ok := mb.sent[s.ID]; ok {
+			continue
+		}
+		mb.sent[s.ID] = s
+		if s.Status == Pending {
+			mb.pending++
+		}
+	}
+	slices.SortFunc(st.Received, func(a, b *received) int {
+		return cmp.Or(a.ReceivedAt.Compare(b.ReceivedAt), cmp.Compare(a.ID, b.ID))
+	})
+	for _, rec := range st.Received {
+		if _, ok := mb.received[rec.ID]; ok {
+			continue
+		}
+		mb.received[rec.ID] = rec
+		if rec.Status == Queued {
+			mb.inbox[rec.Channel] = append(mb.inbox[rec.Channel], rec)
+			mb.queued++
+		}
+	}
+	return nil
+}
+
+// CheckChannel returns an error wrapping ErrInvalid for malformed channel
+// names
+func CheckChannel(name string) error {
+	if !validChannel.MatchString(name) {
+		return fmt.Errorf("%w: channel names are up to 128 letters, digits, '.', '_', '-' and '/'", ErrInvalid)
+	}
+	return nil
+}
+
+// validate checks the channel and payload of a message
+func validate(channel string, payload []byte) error {
+	if err := CheckChannel(channel); err != nil {
+		return err
+	}
+	if len(payload) > MaxPayload {
+		return fmt.Errorf("%w: payloads are limited to %d bytes", ErrInvalid, MaxPayload)
+	}
+	return nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
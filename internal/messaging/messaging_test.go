@@ -0,0 +1,224 @@
+package messaging
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// network connects mailboxes by node ID
+type network struct {
+	mu      sync.Mutex
+	nodes   map[string]*Mailbox
+	offline map[string]bool
+}
+
+func newNetwork(ids ...string) (*network, []*Mailbox) {
+	n := &network{nodes: make(map[string]*Mailbox), offline: make(map[string]bool)}
+	var boxes []*Mailbox
+	for _, id := range ids {
+		mb := New(Options{})
+		mb.Attach(id, n)
+		n.nodes[id] = mb
+		boxes = append(boxes, mb)
+	}
+	return n, boxes
+}
+
+func (n *network) setOffline(id string, offline bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.offline[id] = offline
+}
+
+func (n *network) reach(node string) (*Mailbox, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	mb, ok := n.nodes[node]
+	if !ok || n.offline[node] {
+		return nil, ErrUnreachable
+	}
+	return mb, nil
+}
+
+func (n *network) SendMessage(node string, m Message) error {
+	mb, err := n.reach(node)
+	if err != nil {
+		return err
+	}
+	mb.HandleMessage(m)
+	return nil
+}
+
+func (n *network) SendReceipt(node string, r Receipt) error {
+	mb, err := n.reach(node)
+	if err != nil {
+		return err
+	}
+	mb.HandleReceipt(r)
+	return nil
+}
+
+func TestMailboxDeliversWithReceipts(t *testing.T) {
+	_, boxes := newNetwork("node-a", "node-b")
+	a, b := boxes[0], boxes[1]
+	receipts, stop := a.Receipts(8)
+	defer stop()
+
+	d, err := a.Send("node-b", "orders", []byte("order 42"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, Queued, d.Status)
+	assert.Equal(t, 8, d.Size)
+	assert.Equal(t, Queued, (<-receipts).Status)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m, err := b.Receive(ctx, "orders")
+	require.NoError(t, err)
+	assert.Equal(t, Message{ID: d.ID, From: "node-a", To: "node-b", Channel: "orders", Payload: []byte("order 42"), SentAt: d.SentAt, ExpiresAt: d.ExpiresAt}, m)
+	r := <-receipts
+	assert.Equal(t, Delivered, r.Status)
+	assert.Equal(t, "node-b", r.Node)
+	d, err = a.Status(d.ID)
+	require.NoError(t, err)
+	assert.Equal(t, Delivered, d.Status)
+
+	// Receive waits for the next message
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = a.Send("node-b", "orders", []byte("order 43"), 0)
+	}()
+	m, err = b.Receive(ctx, "orders")
+	require.NoError(t, err)
+	assert.Equal(t, "order 43", string(m.Payload))
+
+	// Messages to this node are delivered locally
+	d, err = b.Send("node-b", "jobs/done", []byte("local"), 0)
+	require.NoError(t, err)
+	m, err = b.Receive(ctx, "jobs/done")
+	require.NoError(t, err)
+	assert.Equal(t, "local", string(m.Payload))
+	d, _ = b.Status(d.ID)
+	assert.Equal(t, Delivered, d.Status)
+
+	short, cancelShort := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancelShort()
+	_, err = b.Receive(short, "orders")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestMailboxQueuesForOfflineNodes(t *testing.T) {
+	n, boxes := newNetwork("node-a", "node-b")
+	a, b := boxes[0], boxes[1]
+	n.setOffline("node-b", true)
+
+	first, err := a.Send("node-b", "orders", []byte("1"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, Pending, first.Status)
+	second, err := a.Send("node-b", "orders", []byte("2"), 0)
+	require.NoError(t, err)
+
+	n.setOffline("node-b", false)
+	a.Retry("node-c")
+	d, _ := a.Status(first.ID)
+	assert.Equal(t, Pending, d.Status, "only the messages of the node retried are sent")
+	a.Retry("node-b")
+	d, _ = a.Status(second.ID)
+	assert.Equal(t, Queued, d.Status)
+
+	// A message sent again, as when its receipt was lost, is queued once
+	b.HandleMessage(Message{ID: first.ID, From: "node-a", To: "node-b", Channel: "orders", Payload: []byte("1"), ExpiresAt: time.Now().Add(time.Hour)})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, want := range []string{"1", "2"} {
+		m, err := b.Receive(ctx, "orders")
+		require.NoError(t, err)
+		assert.Equal(t, want, string(m.Payload))
+	}
+	short, cancelShort := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancelShort()
+	_, err = b.Receive(short, "orders")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestMailboxExpiresAndRejects(t *testing.T) {
+	n, boxes := newNetwork("node-a", "node-b")
+	a, b := boxes[0], boxes[1]
+	b.opts.MaxQueue = 1
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	b.now = a.now
+
+	_, err := a.Send("node-b", "no spaces", nil, 0)
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, err = a.Send("node-b", "orders", make([]byte, MaxPayload+1), 0)
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, err = New(Options{}).Send("node-b", "orders", nil, 0)
+	assert.ErrorIs(t, err, ErrDetached)
+
+	queued, err := a.Send("node-b", "orders", []byte("1"), time.Minute)
+	require.NoError(t, err)
+	full, err := a.Send("node-b", "orders", []byte("2"), time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, Rejected, full.Status)
+	assert.Equal(t, "inbox full", full.Error)
+
+	n.setOffline("node-b", true)
+	pending, err := a.Send("node-b", "orders", []byte("3"), time.Minute)
+	require.NoError(t, err)
+
+	// Past their TTL, messages expire in the outbox and in the inbox
+	now = now.Add(2 * time.Minute)
+	a.Retry("")
+	d, _ := a.Status(pending.ID)
+	assert.Equal(t, Expired, d.Status)
+	n.setOffline("node-b", false)
+	b.Retry("")
+	d, _ = a.Status(queued.ID)
+	assert.Equal(t, Expired, d.Status)
+
+	// Their status is forgotten a while later
+	now = now.Add(2 * retention)
+	a.Retry("")
+	_, err = a.Status(queued.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMailboxPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.json")
+	n := &network{nodes: make(map[string]*Mailbox), offline: map[string]bool{"node-b": true}}
+	a := New(Options{Path: path})
+	a.Attach("node-a", n)
+	n.nodes["node-a"] = a
+	d, err := a.Send("node-b", "orders", []byte("1"), 0)
+	require.NoError(t, err)
+	_, err = a.Send("node-a", "local", []byte("2"), 0)
+	require.NoError(t, err)
+	require.NoError(t, a.Flush())
+
+	reloaded := New(Options{Path: path})
+	require.NoError(t, reloaded.Load())
+	n.nodes["node-a"] = reloaded
+	reloaded.Attach("node-a", n)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m, err := reloaded.Receive(ctx, "local")
+	require.NoError(t, err)
+	assert.Equal(t, "2", string(m.Payload))
+
+	b := New(Options{})
+	b.Attach("node-b", n)
+	n.nodes["node-b"] = b
+	n.setOffline("node-b", false)
+	reloaded.Retry("node-b")
+	m, err = b.Receive(ctx, "orders")
+	require.NoError(t, err)
+	assert.Equal(t, d.ID, m.ID)
+	d, _ = reloaded.Status(d.ID)
+	assert.Equal(t, Delivered, d.Status)
+}
@@ -0,0 +1,209 @@
+package metadata
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// MaxTags is the maximum number of tags on a single record
+	MaxTags = 64
+	// MaxMetadataEntries is the maximum number of custom metadata pairs on a single record
+	MaxMetadataEntries = 128
+	// MaxAttributeLength bounds tag names, metadata keys and metadata values
+	MaxAttributeLength = 256
+)
+
+// AttributePatch describes a partial update of a record's tags and custom metadata
+type AttributePatch struct {
+	Set        map[string]string `json:"set,omitempty"`
+	Remove     []string          `json:"remove,omitempty"`
+	AddTags    []string          `json:"add_tags,omitempty"`
+	RemoveTags []string          `json:"remove_tags,omitempty"`
+}
+
+// Query selects records by tags and metadata. All conditions must match.
+type Query struct {
+	Tags     []string          `json:"tags,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// ValidateAttributes checks tags and metadata against the configured limits
+func ValidateAttributes(tags []string, metadata map[string]string) error {
+	if len(tags) > MaxTags {
+		return fmt.Errorf("too many tags: %d (max %d)", len(tags), MaxTags)
+	}
+	for _, tag := range tags {
+		if strings.TrimSpace(tag) == "" {
+			return fmt.Errorf("tags must not be empty")
+		}
+		if len(tag) > MaxAttributeLength {
+			return fmt.Errorf("tag %q exceeds %d characters", tag[:32], MaxAttributeLength)
+		}
+	}
+
+	if len(metadata) > MaxMetadataEntries {
+		return fmt.Errorf("too many metadata entries: %d (max %d)", len(metadata), MaxMetadataEntries)
+	}
+	for k, v := range metadata {
+		if strings.TrimSpace(k) == "" {
+			return fmt.Errorf("metadata keys must not be empty")
+		}
+		if len(k) > MaxAttributeLength || len(v) > MaxAttributeLength {
+			return fmt.Errorf("metadata entry %q exceeds %d characters", k, MaxAttributeLength)
+		}
+	}
+	return nil
+}
+
+// normalizeTags lowercases, trims, de-duplicates and sorts tags
+func normalizeTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	seen := make(map[string]struct{}, len(tags))
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if _, ok := seen[tag]; ok || tag == "" {
+			continue
+		}
+		seen[tag] = struct{}{}
+		out = append(out, tag)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// UpdateAttributes applies a patch to the tags and metadata of an existing record
+func (s *Store) UpdateAttributes(key string, patch AttributePatch) (FileRecord, error) {
+	rec, err := s.Get(key)
+	if err != nil {
+		return FileRecord{}, err
+	}
+
+	metadata := make(map[string]string, len(rec.Metadata)+len(patch.Set))
+	for k, v := range rec.Metadata {
+		metadata[k] = v
+	}
+	for _, k := range patch.Remove {
+		delete(metadata, k)
+	}
+	for k, v := range patch.Set {
+		metadata[k] = v
+	}
+	if len(metadata) == 0 {
+		metadata = nil
+	}
+
+	removed := make(map[string]struct{}, len(patch.RemoveTags))
+	for _, tag := range normalizeTags(patch.RemoveTags) {
+		removed[tag] = struct{}{}
+	}
+	tags := make([]string, 0, len(rec.Tags)+len(patch.AddTags))
+	for _, tag := range append(append([]string{}, rec.Tags...), patch.AddTags...) {
+		if _, drop := removed[strings.ToLower(strings.TrimSpace(tag))]; !drop {
+			tags = append(tags, tag)
+		}
+	}
+
+	rec.Tags = tags
+	rec.Metadata = metadata
+	entry, err := s.Put(rec)
+	if err != nil {
+		return FileRecord{}, err
+	}
+	return *entry.Record, nil
+}
+
+// Search returns the records matching every condition in the query, sorted by key
+func (s *Store) Search(q Query) []FileRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sets []map[string]struct{}
+	for _, tag := range normalizeTags(q.Tags) {
+		sets = append(sets, s.index.tags[tag])
+	}
+	for k, v := range q.Metadata {
+		sets = append(sets, s.index.metadata[metadataTerm(k, v)])
+	}
+
+	var results []FileRecord
+	if len(sets) == 0 {
+		for _, rec := range s.records {
+			results = append(results, rec)
+		}
+	} else {
+		sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+		for key := range sets[0] {
+			matched := true
+			for _, set := range sets[1:] {
+				if _, ok := set[key]; !ok {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				results = append(results, s.records[key])
+			}
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Key < results[j].Key })
+	return results
+}
+
+// attributeIndex maps tags and metadata pairs to the keys carrying them
+type attributeIndex struct {
+	tags     map[string]map[string]struct{}
+	metadata map[string]map[string]struct{}
+}
+
+func newAttributeIndex() *attributeIndex {
+	return &attributeIndex{
+		tags:     make(map[string]map[string]struct{}),
+		metadata: make(map[string]map[string]struct{}),
+	}
+}
+
+func metadataTerm(k, v string) string {
+	return k + "\x00" + v
+}
+
+func (idx *attributeIndex) add(rec FileRecord) {
+	for _, tag := range rec.Tags {
+		addToSet(idx.tags, tag, rec.Key)
+	}
+	for k, v := range rec.Metadata {
+		addToSet(idx.metadata, metadataTerm(k, v), rec.Key)
+	}
+}
+
+func (idx *attributeIndex) remove(rec FileRecord) {
+	for _, tag := range rec.Tags {
+		removeFromSet(idx.tags, tag, rec.Key)
+	}
+	for k, v := range rec.Metadata {
+		removeFromSet(idx.metadata, metadataTerm(k, v), rec.Key)
+	}
+}
+
+func addToSet(m map[string]map[string]struct{}, term, key string) {
+	set, ok := m[term]
+	if !ok {
+		set = make(map[string]struct{})
+		m[term] = set
+	}
+	set[key] = struct{}{}
+}
+
+func removeFromSet(m map[string]map[string]struct{}, term, key string) {
+	if set, ok := m[term]; ok {
+		delete(set, key)
+		if len(set) == 0 {
+			delete(m, term)
+		}
+	}
+}
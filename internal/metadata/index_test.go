@@ -0,0 +1,76 @@
+package metadata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_SearchByTagsAndMetadata(t *testing.T) {
+	store := NewStore(StoreOpts{})
+
+	_, err := store.Put(FileRecord{Key: "a", Tags: []string{"Invoice", "2024"}, Metadata: map[string]string{"team": "finance"}})
+	require.NoError(t, err)
+	_, err = store.Put(FileRecord{Key: "b", Tags: []string{"invoice"}, Metadata: map[string]string{"team": "ops"}})
+	require.NoError(t, err)
+	_, err = store.Put(FileRecord{Key: "c", Tags: []string{"photo"}})
+	require.NoError(t, err)
+
+	// Tags are normalized to lower case
+	results := store.Search(Query{Tags: []string{"invoice"}})
+	require.Len(t, results, 2)
+	assert.Equal(t, "a", results[0].Key)
+
+	results = store.Search(Query{Tags: []string{"invoice"}, Metadata: map[string]string{"team": "ops"}})
+	require.Len(t, results, 1)
+	assert.Equal(t, "b", results[0].Key)
+
+	assert.Empty(t, store.Search(Query{Tags: []string{"missing"}}))
+	assert.Len(t, store.Search(Query{}), 3)
+}
+
+func TestStore_UpdateAttributes(t *testing.T) {
+	store := NewStore(StoreOpts{})
+	_, err := store.Put(FileRecord{Key: "a", Tags: []string{"draft"}, Metadata: map[string]string{"owner": "alice", "stage": "1"}})
+	require.NoError(t, err)
+
+	rec, err := store.UpdateAttributes("a", AttributePatch{
+		Set:        map[string]string{"stage": "2"},
+		Remove:     []string{"owner"},
+		AddTags:    []string{"final"},
+		RemoveTags: []string{"DRAFT"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"final"}, rec.Tags)
+	assert.Equal(t, map[string]string{"stage": "2"}, rec.Metadata)
+
+	// The index follows the update
+	assert.Empty(t, store.Search(Query{Tags: []string{"draft"}}))
+	assert.Len(t, store.Search(Query{Tags: []string{"final"}, Metadata: map[string]string{"stage": "2"}}), 1)
+
+	_, err = store.UpdateAttributes("missing", AttributePatch{})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestStore_AttributesReplicate(t *testing.T) {
+	primary := NewStore(StoreOpts{})
+	replica := NewStore(StoreOpts{Role: RoleStandby})
+
+	entry, err := primary.Put(FileRecord{Key: "a", Tags: []string{"x"}})
+	require.NoError(t, err)
+	require.NoError(t, replica.Apply(entry))
+	assert.Len(t, replica.Search(Query{Tags: []string{"x"}}), 1)
+
+	// Restoring a snapshot rebuilds the index
+	fresh := NewStore(StoreOpts{Role: RoleStandby})
+	require.NoError(t, fresh.Restore(primary.Snapshot()))
+	assert.Len(t, fresh.Search(Query{Tags: []string{"x"}}), 1)
+}
+
+func TestValidateAttributes(t *testing.T) {
+	assert.NoError(t, ValidateAttributes([]string{"a"}, map[string]string{"k": "v"}))
+	assert.Error(t, ValidateAttributes([]string{" "}, nil))
+	assert.Error(t, ValidateAttributes(nil, map[string]string{"": "v"}))
+	assert.Error(t, ValidateAttributes(make([]string, MaxTags+1), nil))
+}
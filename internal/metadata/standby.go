@@ -0,0 +1,510 @@
+package metadata
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fence is a shared, monotonically increasing epoch register. A node must win
+// a compare-and-swap on the fence before it may act as primary, and a primary
+// that observes a newer epoch stops accepting writes.
+type Fence interface {
+	Current() (uint64, error)
+	CompareAndSwap(old, new uint64) (bool, error)
+}
+
+// MemoryFence is an in-process fence, useful for tests and single-host setups
+type MemoryFence struct {
+	mu    sync.Mutex
+	epoch uint64
+}
+
+// NewMemoryFence creates a new in-memory fence starting at epoch 0
+func NewMemoryFence() *MemoryFence {
+	return &MemoryFence{}
+}
+
+// Current returns the current epoch
+func (f *MemoryFence) Current() (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.epoch, nil
+}
+
+// CompareAndSwap sets the epoch to new if it currently equals old
+func (f *MemoryFence) CompareAndSwap(old, new uint64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.epoch != old || new <= old {
+		return false, nil
+	}
+	f.epoch = new
+	return true, nil
+}
+
+// FileFence stores the epoch in a file on storage shared by primary and standby
+type FileFence struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileFence creates a fence backed by the file at path
+func NewFileFence(path string) *FileFence {
+	return &FileFence{path: path}
+}
+
+// Current returns the epoch recorded in the fence file, or 0 if it does not exist
+func (f *FileFence) Current() (uint64, error) {
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// CompareAndSwap atomically advances the epoch using an exclusive lock file
+func (f *FileFence) CompareAndSwap(old, new uint64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return false, err
+	}
+
+	lockPath := f.path + ".lock"
+	lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		if os.IsExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer func() {
+		_ = lock.Close()
+		_ = os.Remove(lockPath)
+	}()
+
+	current, err := f.Current()
+	if err != nil {
+		return false, err
+	}
+	if current != old || new <= old {
+		return false, nil
+	}
+
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(new, 10)), 0o600); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// TokenEnv is the environment variable holding the token the metadata
+// primary, its standbys and operators authenticate with
+const TokenEnv = "PEERVAULT_METADATA_TOKEN"
+
+// FrameType identifies the payload of a replication frame
+type FrameType string
+
+const (
+	FrameSnapshot  FrameType = "snapshot"
+	FrameEntry     FrameType = "entry"
+	FrameHeartbeat FrameType = "heartbeat"
+)
+
+// Frame is one newline-delimited JSON message on the replication stream
+type Frame struct {
+	Type     FrameType `json:"type"`
+	Snapshot *Snapshot `json:"snapshot,omitempty"`
+	Entry    *Entry    `json:"entry,omitempty"`
+	Index    uint64    `json:"index,omitempty"`
+	Epoch    uint64    `json:"epoch,omitempty"`
+}
+
+// ReplicationHandler streams the snapshot and log of a primary store to a
+// standby. The standby passes the last index it applied as ?from=N, and
+// token as a bearer token.
+func ReplicationHandler(store *Store, heartbeat time.Duration, token string) http.Handler {
+	if heartbeat <= 0 {
+		heartbeat = time.Second
+	}
+
+	return authorize(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if store.Role() != RolePrimary {
+			http.Error(w, "not primary", http.StatusServiceUnavailable)
+			return
+		}
+
+		var from uint64
+		if v := r.URL.Query().Get("from"); v != "" {
+			parsed, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid from parameter", http.StatusBadRequest)
+				return
+			}
+			from = parsed
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		snap, backlog, entries, cancel := store.Follow(from)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+
+		if snap != nil {
+			if err := enc.Encode(Frame{Type: FrameSnapshot, Snapshot: snap}); err != nil {
+				return
+			}
+		}
+		for i := range backlog {
+			if err := enc.Encode(Frame{Type: FrameEntry, Entry: &backlog[i]}); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case entry, ok := <-entries:
+				if !ok {
+					return
+				}
+				if err := enc.Encode(Frame{Type: FrameEntry, Entry: &entry}); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-ticker.C:
+				// Stop streaming once this node has been fenced
+				if store.Role() != RolePrimary {
+					return
+				}
+				frame := Frame{Type: FrameHeartbeat, Index: store.LastIndex(), Epoch: store.Epoch()}
+				if err := enc.Encode(frame); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}))
+}
+
+// StandbyOpts configures a warm standby
+type StandbyOpts struct {
+	// PrimaryURL is the base URL of the primary's replication endpoint
+	PrimaryURL string
+	// AuthToken is sent as a bearer token to the primary, which refuses
+	// standbys without it
+	AuthToken string
+	Store     *Store
+	Fence     Fence
+	// RetryInterval is the delay between reconnection attempts
+	RetryInterval time.Duration
+	HTTPClient    *http.Client
+}
+
+// StandbyStatus reports replication progress of a standby
+type StandbyStatus struct {
+	Role         Role      `json:"role"`
+	PrimaryURL   string    `json:"primary_url"`
+	Connected    bool      `json:"connected"`
+	AppliedIndex uint64    `json:"applied_index"`
+	PrimaryIndex uint64    `json:"primary_index"`
+	Lag          uint64    `json:"lag"`
+	Epoch        uint64    `json:"epoch"`
+	LastContact  time.Time `json:"last_contact"`
+}
+
+// Standby continuously follows a primary and can be promoted to take over
+type Standby struct {
+	opts StandbyOpts
+
+	mu           sync.RWMutex
+	connected    bool
+	primaryIndex uint64
+	lastContact  time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewStandby creates a new standby for the given store
+func NewStandby(opts StandbyOpts) *Standby {
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = time.Second
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{}
+	}
+	if opts.Fence == nil {
+		opts.Fence = opts.Store.fence
+	}
+	return &Standby{opts: opts}
+}
+
+// Start begins following the primary in the background
+func (s *Standby) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	go s.run(ctx, s.done)
+}
+
+// Stop stops following the primary
+func (s *Standby) Stop() {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel = nil
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+func (s *Standby) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	for {
+		if err := s.follow(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("metadata replication stream interrupted", "primary", s.opts.PrimaryURL, "error", err)
+		}
+		s.setConnected(false)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.opts.RetryInterval):
+		}
+	}
+}
+
+// follow consumes one replication stream until it ends
+func (s *Standby) follow(ctx context.Context) error {
+	url := fmt.Sprintf("%s?from=%d", s.opts.PrimaryURL, s.opts.Store.LastIndex())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if s.opts.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.opts.AuthToken)
+	}
+
+	resp, err := s.opts.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("primary returned status %d", resp.StatusCode)
+	}
+	s.setConnected(true)
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var frame Frame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			return fmt.Errorf("invalid replication frame: %w", err)
+		}
+		if err := s.handleFrame(frame); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *Standby) handleFrame(frame Frame) error {
+	switch frame.Type {
+	case FrameSnapshot:
+		if frame.Snapshot == nil {
+			return fmt.Errorf("snapshot frame without payload")
+		}
+		if err := s.opts.Store.Restore(*frame.Snapshot); err != nil {
+			return err
+		}
+		s.touch(frame.Snapshot.Index)
+	case FrameEntry:
+		if frame.Entry == nil {
+			return fmt.Errorf("entry frame without payload")
+		}
+		if err := s.opts.Store.Apply(*frame.Entry); err != nil {
+			return err
+		}
+		s.touch(frame.Entry.Index)
+	case FrameHeartbeat:
+		s.touch(frame.Index)
+	}
+	return nil
+}
+
+func (s *Standby) setConnected(connected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = connected
+}
+
+func (s *Standby) touch(primaryIndex uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastContact = time.Now()
+	if primaryIndex > s.primaryIndex {
+		s.primaryIndex = primaryIndex
+	}
+}
+
+// Status returns the current replication status
+func (s *Standby) Status() StandbyStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	applied := s.opts.Store.LastIndex()
+	var lag uint64
+	if s.primaryIndex > applied {
+		lag = s.primaryIndex - applied
+	}
+
+	return StandbyStatus{
+		Role:         s.opts.Store.Role(),
+		PrimaryURL:   s.opts.PrimaryURL,
+		Connected:    s.connected,
+		AppliedIndex: applied,
+		PrimaryIndex: s.primaryIndex,
+		Lag:          lag,
+		Epoch:        s.opts.Store.Epoch(),
+		LastContact:  s.lastContact,
+	}
+}
+
+// Promote stops following the primary and makes this node the primary in a
+// new epoch. The fence is advanced first, so the old primary rejects any
+// further writes as soon as it observes the new epoch.
+func (s *Standby) Promote(ctx context.Context) (uint64, error) {
+	if s.opts.Store.Role() == RolePrimary {
+		return 0, fmt.Errorf("node is already primary")
+	}
+
+	s.Stop()
+
+	for attempt := 0; attempt < 10; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		current, err := s.opts.Fence.Current()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read fence: %w", err)
+		}
+		next := current + 1
+		if local := s.opts.Store.Epoch(); local >= next {
+			next = local + 1
+		}
+
+		ok, err := s.opts.Fence.CompareAndSwap(current, next)
+		if err != nil {
+			return 0, fmt.Errorf("failed to advance fence: %w", err)
+		}
+		if ok {
+			s.opts.Store.promote(next)
+			slog.Info("metadata standby promoted to primary", "epoch", next, "applied_index", s.opts.Store.LastIndex())
+			return next, nil
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return 0, fmt.Errorf("could not acquire fence after repeated attempts")
+}
+
+// AdminHandler exposes status and promotion of a metadata node:
+//
+//	GET  /metadata/status
+//	POST /metadata/promote
+//
+// Requests must carry token as a bearer token. standby may be nil when the
+// node runs as a primary.
+func AdminHandler(store *Store, standby *Standby, token string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /metadata/status", func(w http.ResponseWriter, r *http.Request) {
+		status := StandbyStatus{
+			Role:         store.Role(),
+			AppliedIndex: store.LastIndex(),
+			Epoch:        store.Epoch(),
+		}
+		if standby != nil {
+			status = standby.Status()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(status)
+	})
+
+	mux.HandleFunc("POST /metadata/promote", func(w http.ResponseWriter, r *http.Request) {
+		if standby == nil {
+			http.Error(w, "node is not a standby", http.StatusConflict)
+			return
+		}
+		epoch, err := standby.Promote(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"role":  store.Role(),
+			"epoch": epoch,
+			"index": store.LastIndex(),
+		})
+	})
+
+	return authorize(token, mux)
+}
+
+// authorize requires the bearer token on every request. Without a token
+// every request is refused, as these endpoints replicate and promote.
+func authorize(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,111 @@
+package metadata
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStandby_FollowAndPromote(t *testing.T) {
+	fence := NewMemoryFence()
+	primary := NewStore(StoreOpts{Fence: fence})
+
+	_, err := primary.Put(FileRecord{Key: "before"})
+	require.NoError(t, err)
+
+	server := httptest.NewServer(ReplicationHandler(primary, 50*time.Millisecond, "token"))
+	defer server.Close()
+
+	replica := NewStore(StoreOpts{Role: RoleStandby, Fence: fence})
+	standby := NewStandby(StandbyOpts{
+		PrimaryURL:    server.URL,
+		AuthToken:     "token",
+		Store:         replica,
+		RetryInterval: 20 * time.Millisecond,
+	})
+	standby.Start()
+	defer standby.Stop()
+
+	_, err = primary.Put(FileRecord{Key: "after"})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return replica.LastIndex() == primary.LastIndex()
+	}, 2*time.Second, 10*time.Millisecond)
+
+	status := standby.Status()
+	assert.True(t, status.Connected)
+	assert.Equal(t, uint64(0), status.Lag)
+
+	epoch, err := standby.Promote(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), epoch)
+	assert.Equal(t, RolePrimary, replica.Role())
+
+	// The old primary is fenced and the new primary accepts writes
+	_, err = primary.Put(FileRecord{Key: "split-brain"})
+	assert.ErrorIs(t, err, ErrFenced)
+
+	entry, err := replica.Put(FileRecord{Key: "promoted"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), entry.Epoch)
+	assert.Equal(t, uint64(3), entry.Index)
+}
+
+func TestAdminHandler(t *testing.T) {
+	replica := NewStore(StoreOpts{Role: RoleStandby})
+	standby := NewStandby(StandbyOpts{PrimaryURL: "http://127.0.0.1:0", Store: replica})
+	handler := AdminHandler(replica, standby, "token")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, authorized(httptest.NewRequest(http.MethodGet, "/metadata/status", nil), "token"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"role":"standby"`)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, authorized(httptest.NewRequest(http.MethodPost, "/metadata/promote", nil), "token"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, RolePrimary, replica.Role())
+
+	// Promoting twice is rejected
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, authorized(httptest.NewRequest(http.MethodPost, "/metadata/promote", nil), "token"))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+}
+
+func TestHandlersRequireToken(t *testing.T) {
+	primary := NewStore(StoreOpts{})
+	replica := NewStore(StoreOpts{Role: RoleStandby})
+	standby := NewStandby(StandbyOpts{PrimaryURL: "http://127.0.0.1:0", Store: replica})
+	handlers := map[string]http.Handler{
+		"/metadata/replication": ReplicationHandler(primary, time.Second, "token"),
+		"/metadata/status":      AdminHandler(replica, standby, "token"),
+		"/metadata/promote":     AdminHandler(replica, standby, "token"),
+	}
+	for path, handler := range handlers {
+		for name, req := range map[string]*http.Request{
+			"missing": httptest.NewRequest(http.MethodPost, path, nil),
+			"wrong":   authorized(httptest.NewRequest(http.MethodPost, path, nil), "other"),
+		} {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusUnauthorized, rec.Code, "%s token on %s", name, path)
+		}
+	}
+	assert.Equal(t, RoleStandby, replica.Role(), "nothing was promoted")
+
+	// Without a configured token nothing is served
+	rec := httptest.NewRecorder()
+	AdminHandler(replica, standby, "").ServeHTTP(rec, authorized(httptest.NewRequest(http.MethodGet, "/metadata/status", nil), ""))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func authorized(req *http.Request, token string) *http.Request {
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
@@ -0,0 +1,384 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotPrimary is returned when a write is attempted on a node that is not the primary
+	ErrNotPrimary = errors.New("metadata store is not primary")
+	// ErrFenced is returned when a newer epoch has been claimed by another node
+	ErrFenced = errors.New("metadata store has been fenced by a newer epoch")
+	// ErrOutOfOrder is returned when a replicated entry does not follow the last applied index
+	ErrOutOfOrder = errors.New("replicated entry is out of order")
+	// ErrNotFound is returned when a record does not exist
+	ErrNotFound = errors.New("metadata record not found")
+)
+
+// Role describes how a metadata store participates in replication
+type Role string
+
+const (
+	RolePrimary Role = "primary"
+	RoleStandby Role = "standby"
+	RoleFenced  Role = "fenced"
+)
+
+// OpType identifies the mutation carried by a log entry
+type OpType string
+
+const (
+	OpPut    OpType = "put"
+	OpDelete OpType = "delete"
+)
+
+// FileRecord is the metadata tracked for a stored file
+type FileRecord struct {
+	Key         string            `json:"key"`
+	Name        string            `json:"name,omitempty"`
+	HashedKey   string            `json:"hashed_key,omitempty"`
+	Size        int64             `json:"size"`
+	ContentType string            `json:"content_type,omitempty"`
+	Hash        string            `json:"hash,omitempty"`
+	MerkleRoot  string            `json:"merkle_root,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	// StorageClass is the tier holding the current content; empty means StorageClassHot
+	StorageClass string `json:"storage_class,omitempty"`
+	// Versions lists noncurrent versions, newest first
+	Versions []VersionRecord `json:"versions,omitempty"`
+}
+
+// Entry is a single mutation in the metadata log
+type Entry struct {
+	Index     uint64      `json:"index"`
+	Epoch     uint64      `json:"epoch"`
+	Op        OpType      `json:"op"`
+	Key       string      `json:"key"`
+	Record    *FileRecord `json:"record,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// Snapshot is a point-in-time copy of the store state
+type Snapshot struct {
+	Index   uint64                `json:"index"`
+	Epoch   uint64                `json:"epoch"`
+	Records map[string]FileRecord `json:"records"`
+	TakenAt time.Time             `json:"taken_at"`
+}
+
+// StoreOpts configures a metadata store
+type StoreOpts struct {
+	// Role is the initial role; defaults to RolePrimary
+	Role Role
+	// Fence guards against split-brain; defaults to an in-memory fence
+	Fence Fence
+	// MaxLogEntries bounds the in-memory log kept for catching up replicas
+	MaxLogEntries int
+}
+
+// Store is the replicated metadata state machine
+type Store struct {
+	mu          sync.RWMutex
+	records     map[string]FileRecord
+	log         []Entry
+	maxLog      int
+	lastIndex   uint64
+	epoch       uint64
+	role        Role
+	fence       Fence
+	index       *attributeIndex
+	usage       *usageIndex
+	subscribers map[int]chan Entry
+	nextSubID   int
+}
+
+// NewStore creates a new metadata store
+func NewStore(opts StoreOpts) *Store {
+	if opts.Role == "" {
+		opts.Role = RolePrimary
+	}
+	if opts.Fence == nil {
+		opts.Fence = NewMemoryFence()
+	}
+	if opts.MaxLogEntries <= 0 {
+		opts.MaxLogEntries = 10000
+	}
+
+	epoch, err := opts.Fence.Current()
+	if err != nil {
+		epoch = 0
+	}
+
+	return &Store{
+		records:     make(map[string]FileRecord),
+		maxLog:      opts.MaxLogEntries,
+		epoch:       epoch,
+		role:        opts.Role,
+		fence:       opts.Fence,
+		index:       newAttributeIndex(),
+		usage:       newUsageIndex(),
+		subscribers: make(map[int]chan Entry),
+	}
+}
+
+// Role returns the current role of the store
+func (s *Store) Role() Role {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.role
+}
+
+// Epoch returns the epoch the store is operating in
+func (s *Store) Epoch() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.epoch
+}
+
+// LastIndex returns the index of the last applied entry
+func (s *Store) LastIndex() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastIndex
+}
+
+// Get returns the record stored under key
+func (s *Store) Get(key string) (FileRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rec, ok := s.records[key]
+	if !ok {
+		return FileRecord{}, ErrNotFound
+	}
+	return rec, nil
+}
+
+// List returns all records sorted by key
+func (s *Store) List() []FileRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := make([]FileRecord, 0, len(s.records))
+	for _, rec := range s.records {
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+	return records
+}
+
+// Put creates or replaces a record. Only the primary accepts writes.
+func (s *Store) Put(rec FileRecord) (Entry, error) {
+	if rec.Key == "" {
+		return Entry{}, fmt.Errorf("record key is required")
+	}
+	if err := ValidateAttributes(rec.Tags, rec.Metadata); err != nil {
+		return Entry{}, err
+	}
+	rec.Tags = normalizeTags(rec.Tags)
+	now := time.Now()
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = now
+	}
+	rec.UpdatedAt = now
+	return s.commit(OpPut, rec.Key, &rec)
+}
+
+// Delete removes a record. Only the primary accepts writes.
+func (s *Store) Delete(key string) (Entry, error) {
+	if _, err := s.Get(key); err != nil {
+		return Entry{}, err
+	}
+	return s.commit(OpDelete, key, nil)
+}
+
+// commit appends a new entry to the log after checking the fence
+func (s *Store) commit(op OpType, key string, rec *FileRecord) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.role != RolePrimary {
+		if s.role == RoleFenced {
+			return Entry{}, ErrFenced
+		}
+		return Entry{}, ErrNotPrimary
+	}
+
+	current, err := s.fence.Current()
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to read fence: %w", err)
+	}
+	if current > s.epoch {
+		s.role = RoleFenced
+		return Entry{}, ErrFenced
+	}
+
+	if op == OpPut {
+		if old, ok := s.records[key]; ok {
+			carryVersions(old, rec, time.Now())
+		}
+	}
+
+	entry := Entry{
+		Index:     s.lastIndex + 1,
+		Epoch:     s.epoch,
+		Op:        op,
+		Key:       key,
+		Record:    rec,
+		Timestamp: time.Now(),
+	}
+	s.applyLocked(entry)
+	return entry, nil
+}
+
+// Apply applies an entry replicated from the primary
+func (s *Store) Apply(entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.role == RolePrimary {
+		return fmt.Errorf("primary does not accept replicated entries")
+	}
+	if entry.Index != s.lastIndex+1 {
+		return fmt.Errorf("%w: expected index %d, got %d", ErrOutOfOrder, s.lastIndex+1, entry.Index)
+	}
+	if entry.Epoch < s.epoch {
+		return fmt.Errorf("%w: entry epoch %d is older than %d", ErrFenced, entry.Epoch, s.epoch)
+	}
+
+	s.epoch = entry.Epoch
+	s.applyLocked(entry)
+	return nil
+}
+
+// applyLocked mutates state, appends to the log and notifies followers
+func (s *Store) applyLocked(entry Entry) {
+	if old, ok := s.records[entry.Key]; ok {
+		s.index.remove(old)
+		s.usage.remove(old)
+	}
+
+	switch entry.Op {
+	case OpPut:
+		if entry.Record != nil {
+			s.records[entry.Key] = *entry.Record
+			s.index.add(*entry.Record)
+			s.usage.add(*entry.Record)
+		}
+	case OpDelete:
+		delete(s.records, entry.Key)
+	}
+
+	s.lastIndex = entry.Index
+	s.log = append(s.log, entry)
+	if len(s.log) > s.maxLog {
+		s.log = s.log[len(s.log)-s.maxLog:]
+	}
+
+	for id, ch := range s.subscribers {
+		select {
+		case ch <- entry:
+		default:
+			// Slow follower; drop it so it reconnects and resyncs from a snapshot
+			close(ch)
+			delete(s.subscribers, id)
+		}
+	}
+}
+
+// Snapshot returns a copy of the current state
+func (s *Store) Snapshot() Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.snapshotLocked()
+}
+
+func (s *Store) snapshotLocked() Snapshot {
+	records := make(map[string]FileRecord, len(s.records))
+	for k, v := range s.records {
+		records[k] = v
+	}
+	return Snapshot{
+		Index:   s.lastIndex,
+		Epoch:   s.epoch,
+		Records: records,
+		TakenAt: time.Now(),
+	}
+}
+
+// Restore replaces the store state with a snapshot
+func (s *Store) Restore(snap Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.role == RolePrimary {
+		return fmt.Errorf("primary cannot be restored from a snapshot")
+	}
+	if snap.Epoch < s.epoch {
+		return fmt.Errorf("%w: snapshot epoch %d is older than %d", ErrFenced, snap.Epoch, s.epoch)
+	}
+
+	s.records = make(map[string]FileRecord, len(snap.Records))
+	s.index = newAttributeIndex()
+	s.usage = newUsageIndex()
+	for k, v := range snap.Records {
+		s.records[k] = v
+		s.index.add(v)
+		s.usage.add(v)
+	}
+	s.lastIndex = snap.Index
+	s.epoch = snap.Epoch
+	s.log = nil
+	return nil
+}
+
+// Follow atomically captures what a follower at index from needs to catch up
+// and subscribes it to subsequent entries. A nil snapshot means the backlog
+// alone is sufficient.
+func (s *Store) Follow(from uint64) (*Snapshot, []Entry, <-chan Entry, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var snap *Snapshot
+	var backlog []Entry
+
+	switch {
+	case from == s.lastIndex:
+	case from < s.lastIndex && len(s.log) > 0 && s.log[0].Index <= from+1:
+		start := int(from + 1 - s.log[0].Index)
+		backlog = append(backlog, s.log[start:]...)
+	default:
+		captured := s.snapshotLocked()
+		snap = &captured
+	}
+
+	id := s.nextSubID
+	s.nextSubID++
+	ch := make(chan Entry, 256)
+	s.subscribers[id] = ch
+
+	cancel := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if sub, ok := s.subscribers[id]; ok {
+			close(sub)
+			delete(s.subscribers, id)
+		}
+	}
+	return snap, backlog, ch, cancel
+}
+
+// promote switches the store to primary in the given epoch
+func (s *Store) promote(epoch uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.role = RolePrimary
+	s.epoch = epoch
+}
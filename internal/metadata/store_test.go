@@ -0,0 +1,106 @@
+package metadata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_PutGetDelete(t *testing.T) {
+	store := NewStore(StoreOpts{})
+
+	entry, err := store.Put(FileRecord{Key: "a.txt", Size: 10})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), entry.Index)
+
+	rec, err := store.Get("a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), rec.Size)
+	assert.False(t, rec.CreatedAt.IsZero())
+
+	_, err = store.Delete("a.txt")
+	require.NoError(t, err)
+	_, err = store.Get("a.txt")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, uint64(2), store.LastIndex())
+}
+
+func TestStore_StandbyRejectsWrites(t *testing.T) {
+	store := NewStore(StoreOpts{Role: RoleStandby})
+	_, err := store.Put(FileRecord{Key: "a"})
+	assert.ErrorIs(t, err, ErrNotPrimary)
+}
+
+func TestStore_ApplyInOrder(t *testing.T) {
+	primary := NewStore(StoreOpts{})
+	replica := NewStore(StoreOpts{Role: RoleStandby})
+
+	e1, err := primary.Put(FileRecord{Key: "a"})
+	require.NoError(t, err)
+	e2, err := primary.Put(FileRecord{Key: "b"})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, replica.Apply(e2), ErrOutOfOrder)
+	require.NoError(t, replica.Apply(e1))
+	require.NoError(t, replica.Apply(e2))
+	assert.Len(t, replica.List(), 2)
+}
+
+func TestStore_FencedPrimary(t *testing.T) {
+	fence := NewMemoryFence()
+	store := NewStore(StoreOpts{Fence: fence})
+
+	_, err := store.Put(FileRecord{Key: "a"})
+	require.NoError(t, err)
+
+	ok, err := fence.CompareAndSwap(0, 1)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, err = store.Put(FileRecord{Key: "b"})
+	assert.ErrorIs(t, err, ErrFenced)
+	assert.Equal(t, RoleFenced, store.Role())
+}
+
+func TestStore_FollowBacklogAndSnapshot(t *testing.T) {
+	store := NewStore(StoreOpts{MaxLogEntries: 2})
+	for _, key := range []string{"a", "b", "c"} {
+		_, err := store.Put(FileRecord{Key: key})
+		require.NoError(t, err)
+	}
+
+	// Index 1 is still covered by the log (entries 2 and 3)
+	snap, backlog, _, cancel := store.Follow(1)
+	cancel()
+	assert.Nil(t, snap)
+	assert.Len(t, backlog, 2)
+
+	// Index 0 was compacted away, so a snapshot is needed
+	snap, backlog, _, cancel = store.Follow(0)
+	cancel()
+	require.NotNil(t, snap)
+	assert.Empty(t, backlog)
+	assert.Equal(t, uint64(3), snap.Index)
+	assert.Len(t, snap.Records, 3)
+}
+
+func TestFileFence(t *testing.T) {
+	fence := NewFileFence(t.TempDir() + "/fence")
+
+	epoch, err := fence.Current()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), epoch)
+
+	ok, err := fence.CompareAndSwap(0, 1)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = fence.CompareAndSwap(0, 2)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	epoch, err = fence.Current()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), epoch)
+}
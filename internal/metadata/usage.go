@@ -0,0 +1,145 @@
+package metadata
+
+import (
+	"sort"
+	"strings"
+)
+
+// Usage counts the files and bytes of a set of records
+type Usage struct {
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+	// Versions and VersionBytes count the noncurrent versions kept
+	Versions     int64 `json:"versions"`
+	VersionBytes int64 `json:"version_bytes"`
+}
+
+// PrefixUsage is the usage of the records under a directory prefix
+type PrefixUsage struct {
+	Prefix string `json:"prefix"`
+	Usage
+}
+
+// TenantUsage is the usage of the records of a tenant
+type TenantUsage struct {
+	Tenant string `json:"tenant"`
+	Usage
+}
+
+// Tenant returns the tenant of a record: its "tenant" metadata entry, or
+// else its owner
+func (r FileRecord) Tenant() string {
+	if tenant := r.Metadata["tenant"]; tenant != "" {
+		return tenant
+	}
+	return r.Owner
+}
+
+// NormalizePrefix turns a directory prefix into the form usage is kept
+// under: no leading slash and a trailing one, "" for the root
+func NormalizePrefix(prefix string) string {
+	prefix = strings.TrimLeft(prefix, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// Usage returns the usage under a directory prefix, "" for every record,
+// followed by that of the directories up to depth levels below it, sorted
+// by prefix. Usage is kept up to date on every write, so this does not
+// walk the records.
+func (s *Store) Usage(prefix string, depth int) []PrefixUsage {
+	prefix = NormalizePrefix(prefix)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []PrefixUsage{{Prefix: prefix, Usage: s.usage.prefixes[prefix]}}
+	if depth <= 0 {
+		return result
+	}
+	base := strings.Count(prefix, "/")
+	for p, u := range s.usage.prefixes {
+		if p != prefix && strings.HasPrefix(p, prefix) && strings.Count(p, "/")-base <= depth {
+			result = append(result, PrefixUsage{Prefix: p, Usage: u})
+		}
+	}
+	sort.Slice(result[1:], func(i, j int) bool { return result[i+1].Prefix < result[j+1].Prefix })
+	return result
+}
+
+// TenantUsage returns the usage of every tenant, sorted by tenant. Records
+// without a tenant only count under the prefixes.
+func (s *Store) TenantUsage() []TenantUsage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]TenantUsage, 0, len(s.usage.tenants))
+	for tenant, u := range s.usage.tenants {
+		result = append(result, TenantUsage{Tenant: tenant, Usage: u})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Tenant < result[j].Tenant })
+	return result
+}
+
+// usageIndex keeps the usage of every directory prefix holding records and
+// of every tenant
+type usageIndex struct {
+	prefixes map[string]Usage
+	tenants  map[string]Usage
+}
+
+func newUsageIndex() *usageIndex {
+	return &usageIndex{
+		prefixes: make(map[string]Usage),
+		tenants:  make(map[string]Usage),
+	}
+}
+
+// usageOf returns the usage of a single record
+func usageOf(rec FileRecord) Usage {
+	u := Usage{Objects: 1, Bytes: rec.Size, Versions: int64(len(rec.Versions))}
+	for _, v := range rec.Versions {
+		u.VersionBytes += v.Size
+	}
+	return u
+}
+
+func (idx *usageIndex) add(rec FileRecord) {
+	idx.apply(rec, 1)
+}
+
+func (idx *usageIndex) remove(rec FileRecord) {
+	idx.apply(rec, -1)
+}
+
+// apply adds the usage of rec, times sign, to the root, every directory
+// above its key and its tenant
+func (idx *usageIndex) apply(rec FileRecord, sign int64) {
+	u := usageOf(rec)
+	addUsage(idx.prefixes, "", u, sign)
+	key := strings.TrimLeft(rec.Key, "/")
+	for i := range len(key) {
+		if key[i] == '/' {
+			addUsage(idx.prefixes, key[:i+1], u, sign)
+		}
+	}
+	if tenant := rec.Tenant(); tenant != "" {
+		addUsage(idx.tenants, tenant, u, sign)
+	}
+}
+
+// addUsage adds u times sign to the usage of term, dropping terms left
+// without records
+func addUsage(m map[string]Usage, term string, u Usage, sign int64) {
+	total := m[term]
+	total.Objects += sign * u.Objects
+	total.Bytes += sign * u.Bytes
+	total.Versions += sign * u.Versions
+	total.VersionBytes += sign * u.VersionBytes
+	if total.Objects <= 0 && term != "" {
+		delete(m, term)
+		return
+	}
+	m[term] = total
+}
@@ -0,0 +1,66 @@
+package metadata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_Usage(t *testing.T) {
+	store := NewStore(StoreOpts{})
+	put := func(rec FileRecord) {
+		t.Helper()
+		_, err := store.Put(rec)
+		require.NoError(t, err)
+	}
+	put(FileRecord{Key: "photos/2024/a.jpg", Size: 100, Hash: "a1", Owner: "alice"})
+	put(FileRecord{Key: "photos/2024/b.jpg", Size: 50, Hash: "b1", Owner: "alice", Metadata: map[string]string{"tenant": "acme"}})
+	put(FileRecord{Key: "photos/2025/c.jpg", Size: 25, Hash: "c1"})
+	put(FileRecord{Key: "readme.txt", Size: 5, Hash: "r1", Owner: "bob"})
+
+	assert.Equal(t, []PrefixUsage{{Prefix: "", Usage: Usage{Objects: 4, Bytes: 180}}}, store.Usage("", 0))
+	assert.Equal(t, []PrefixUsage{
+		{Prefix: "photos/", Usage: Usage{Objects: 3, Bytes: 175}},
+		{Prefix: "photos/2024/", Usage: Usage{Objects: 2, Bytes: 150}},
+		{Prefix: "photos/2025/", Usage: Usage{Objects: 1, Bytes: 25}},
+	}, store.Usage("/photos", 1))
+	assert.Equal(t, []PrefixUsage{{Prefix: "missing/"}}, store.Usage("missing", 1))
+
+	// Tenants come from the tenant metadata entry, or else the owner
+	assert.Equal(t, []TenantUsage{
+		{Tenant: "acme", Usage: Usage{Objects: 1, Bytes: 50}},
+		{Tenant: "alice", Usage: Usage{Objects: 1, Bytes: 100}},
+		{Tenant: "bob", Usage: Usage{Objects: 1, Bytes: 5}},
+	}, store.TenantUsage())
+
+	// Replacing the content keeps the previous one as a version
+	put(FileRecord{Key: "photos/2024/a.jpg", Size: 120, Hash: "a2", Owner: "alice"})
+	assert.Equal(t, Usage{Objects: 2, Bytes: 170, Versions: 1, VersionBytes: 100}, store.Usage("photos/2024", 0)[0].Usage)
+
+	// Emptied directories and tenants are dropped
+	_, err := store.Delete("photos/2025/c.jpg")
+	require.NoError(t, err)
+	_, err = store.Delete("readme.txt")
+	require.NoError(t, err)
+	assert.Len(t, store.Usage("", 5), 3)
+	assert.Len(t, store.TenantUsage(), 2)
+	assert.Equal(t, Usage{Objects: 2, Bytes: 170, Versions: 1, VersionBytes: 100}, store.Usage("", 0)[0].Usage)
+}
+
+func TestStore_UsageReplicates(t *testing.T) {
+	primary := NewStore(StoreOpts{})
+	_, err := primary.Put(FileRecord{Key: "a/b", Size: 10, Owner: "alice"})
+	require.NoError(t, err)
+
+	// A standby restored from a snapshot rebuilds the usage
+	replica := NewStore(StoreOpts{Role: RoleStandby})
+	require.NoError(t, replica.Restore(primary.Snapshot()))
+	assert.Equal(t, primary.Usage("", 1), replica.Usage("", 1))
+
+	entry, err := primary.Put(FileRecord{Key: "a/c", Size: 5, Owner: "bob"})
+	require.NoError(t, err)
+	require.NoError(t, replica.Apply(entry))
+	assert.Equal(t, primary.Usage("", 1), replica.Usage("", 1))
+	assert.Equal(t, primary.TenantUsage(), replica.TenantUsage())
+}
@@ -0,0 +1,115 @@
+package metadata
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// StorageClassHot is the default tier for new content
+	StorageClassHot = "hot"
+	// StorageClassCold is the tier for rarely accessed content
+	StorageClassCold = "cold"
+
+	// MaxVersions bounds how many noncurrent versions a record keeps
+	MaxVersions = 32
+)
+
+// VersionRecord describes a noncurrent version of a file. Its content stays
+// addressable by hash until the version is deleted.
+type VersionRecord struct {
+	ID              string    `json:"id"`
+	Size            int64     `json:"size"`
+	Hash            string    `json:"hash"`
+	StorageClass    string    `json:"storage_class,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	NoncurrentSince time.Time `json:"noncurrent_since"`
+}
+
+// Class returns the storage class of the current content
+func (r FileRecord) Class() string {
+	if r.StorageClass == "" {
+		return StorageClassHot
+	}
+	return r.StorageClass
+}
+
+// carryVersions keeps the history of a record across a put. Replacing the
+// content turns the previous content into a noncurrent version and resets
+// the tier; attribute-only updates keep the history and tier as they were.
+func carryVersions(old FileRecord, rec *FileRecord, now time.Time) {
+	if old.Hash != "" && rec.Hash != "" && old.Hash != rec.Hash {
+		versions := make([]VersionRecord, 0, len(old.Versions)+1)
+		versions = append(versions, VersionRecord{
+			ID:              old.Hash,
+			Size:            old.Size,
+			Hash:            old.Hash,
+			StorageClass:    old.StorageClass,
+			CreatedAt:       old.CreatedAt,
+			NoncurrentSince: now,
+		})
+		for _, v := range old.Versions {
+			// Restoring an older content hash makes it current again
+			if v.Hash != rec.Hash {
+				versions = append(versions, v)
+			}
+		}
+		if len(versions) > MaxVersions {
+			versions = versions[:MaxVersions]
+		}
+		rec.Versions = versions
+		return
+	}
+
+	if rec.Versions == nil {
+		rec.Versions = old.Versions
+	}
+	if rec.StorageClass == "" {
+		rec.StorageClass = old.StorageClass
+	}
+}
+
+// SetStorageClass records that the current content of key moved to another tier
+func (s *Store) SetStorageClass(key, class string) (FileRecord, error) {
+	if class == "" {
+		return FileRecord{}, fmt.Errorf("storage class is required")
+	}
+	rec, err := s.Get(key)
+	if err != nil {
+		return FileRecord{}, err
+	}
+	rec.StorageClass = class
+	entry, err := s.Put(rec)
+	if err != nil {
+		return FileRecord{}, err
+	}
+	return *entry.Record, nil
+}
+
+// DeleteVersion removes a noncurrent version of key
+func (s *Store) DeleteVersion(key, versionID string) (FileRecord, error) {
+	rec, err := s.Get(key)
+	if err != nil {
+		return FileRecord{}, err
+	}
+
+	versions := make([]VersionRecord, 0, len(rec.Versions))
+	found := false
+	for _, v := range rec.Versions {
+		if v.ID == versionID {
+			found = true
+			continue
+		}
+		versions = append(versions, v)
+	}
+	if !found {
+		return FileRecord{}, fmt.Errorf("%w: version %s of %s", ErrNotFound, versionID, key)
+	}
+
+	rec.Versions = versions
+	entry, err := s.Put(rec)
+	if err != nil {
+		return FileRecord{}, err
+	}
+	return *entry.Record, nil
+}
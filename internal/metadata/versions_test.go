@@ -0,0 +1,48 @@
+package metadata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_NoncurrentVersions(t *testing.T) {
+	store := NewStore(StoreOpts{})
+
+	_, err := store.Put(FileRecord{Key: "a.txt", Size: 1, Hash: "h1"})
+	require.NoError(t, err)
+	_, err = store.SetStorageClass("a.txt", StorageClassCold)
+	require.NoError(t, err)
+
+	// Attribute updates keep history and tier
+	rec, err := store.UpdateAttributes("a.txt", AttributePatch{AddTags: []string{"x"}})
+	require.NoError(t, err)
+	assert.Empty(t, rec.Versions)
+	assert.Equal(t, StorageClassCold, rec.Class())
+
+	// New content pushes the old one into the history and resets the tier
+	_, err = store.Put(FileRecord{Key: "a.txt", Size: 2, Hash: "h2"})
+	require.NoError(t, err)
+	_, err = store.Put(FileRecord{Key: "a.txt", Size: 3, Hash: "h3"})
+	require.NoError(t, err)
+
+	rec, err = store.Get("a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, StorageClassHot, rec.Class())
+	require.Len(t, rec.Versions, 2)
+	assert.Equal(t, "h2", rec.Versions[0].ID)
+	assert.Equal(t, "h1", rec.Versions[1].ID)
+	assert.Equal(t, StorageClassCold, rec.Versions[1].StorageClass)
+	assert.False(t, rec.Versions[0].NoncurrentSince.IsZero())
+
+	rec, err = store.DeleteVersion("a.txt", "h1")
+	require.NoError(t, err)
+	require.Len(t, rec.Versions, 1)
+	_, err = store.DeleteVersion("a.txt", "h1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	rec, err = store.DeleteVersion("a.txt", "h2")
+	require.NoError(t, err)
+	assert.Empty(t, rec.Versions)
+}
@@ -0,0 +1,179 @@
+// Package notify delivers messages from a node to people and other
+// systems: email through an SMTP server and HTTP webhooks. Webhooks are
+// posted at once or delivered by the jobs of a queue, which retries them
+// until the receiver accepts them.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/queue"
+)
+
+// WebhookJob is the kind of queued jobs delivering webhooks
+const WebhookJob = "webhook"
+
+// WebhookTimeout bounds each queued delivery of a webhook
+const WebhookTimeout = 30 * time.Second
+
+// ErrNoSMTP is returned when mail is sent without an SMTP server
+var ErrNoSMTP = errors.New("notify: no SMTP server configured")
+
+// SMTPConfig is the mail server a node sends email through
+type SMTPConfig struct {
+	// Addr is the server's host:port; empty disables email
+	Addr string
+	From string
+	// Username and Password authenticate with PLAIN auth when set
+	Username string
+	Password string
+}
+
+// Enabled reports whether a server is configured
+func (c SMTPConfig) Enabled() bool { return c.Addr != "" }
+
+// Attachment is a file attached to an email
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Mail sends a plain text email with optional attachments
+func (c SMTPConfig) Mail(to []string, subject, text string, attachments ...Attachment) error {
+	if !c.Enabled() {
+		return ErrNoSMTP
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fmt.Fprintf(&body, "From: %s\r\n", c.From)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&body, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&body, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", w.Boundary())
+
+	part, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(part, "%s\r\n", strings.ReplaceAll(text, "\n", "\r\n"))
+
+	for _, a := range attachments {
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+		})
+		if err != nil {
+			return err
+		}
+		encoded := base64.StdEncoding.EncodeToString(a.Data)
+		for len(encoded) > 76 {
+			fmt.Fprintf(part, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(part, "%s\r\n", encoded)
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if c.Username != "" {
+		host, _, err := net.SplitHostPort(c.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", c.Username, c.Password, host)
+	}
+	return smtp.SendMail(c.Addr, auth, c.From, to, body.Bytes())
+}
+
+// Post sends body to a webhook and fails unless it answers with a 2xx
+// status
+func Post(ctx context.Context, client *http.Client, url, contentType string, header http.Header, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+	return nil
+}
+
+// StatusError is returned for webhooks answering with a status other than
+// 2xx
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string { return "webhook returned " + e.Status }
+
+// Webhook is a delivery to a webhook
+type Webhook struct {
+	URL         string      `json:"url"`
+	ContentType string      `json:"content_type"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body"`
+}
+
+// Deliver posts a webhook with client, or, when q is set, queues it to be
+// delivered by the jobs HandleWebhooks runs
+func Deliver(ctx context.Context, q *queue.Queue, client *http.Client, w Webhook) error {
+	if q == nil {
+		return Post(ctx, client, w.URL, w.ContentType, w.Header, w.Body)
+	}
+	_, err := q.Enqueue(WebhookJob, w, queue.EnqueueOptions{})
+	return err
+}
+
+// HandleWebhooks delivers the webhooks queued on q with client, nil for
+// one with WebhookTimeout. Deliveries the receiver rejects with a client
+// error other than 408 or 429 are not retried.
+func HandleWebhooks(q *queue.Queue, client *http.Client) error {
+	if client == nil {
+		client = &http.Client{Timeout: WebhookTimeout}
+	}
+	return q.Handle(WebhookJob, func(ctx context.Context, payload json.RawMessage) error {
+		var w Webhook
+		if err := json.Unmarshal(payload, &w); err != nil {
+			return fmt.Errorf("%w: %v", queue.ErrPermanent, err)
+		}
+		ctx, cancel := context.WithTimeout(ctx, WebhookTimeout)
+		defer cancel()
+		err := Post(ctx, client, w.URL, w.ContentType, w.Header, w.Body)
+		var status *StatusError
+		if errors.As(err, &status) && status.Code >= 400 && status.Code < 500 &&
+			status.Code != http.StatusRequestTimeout && status.Code != http.StatusTooManyRequests {
+			return fmt.Errorf("%w: %w", queue.ErrPermanent, err)
+		}
+		return err
+	})
+}
@@ -0,0 +1,153 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/queue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTP accepts one message and returns what was sent
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { _, _ = io.WriteString(conn, s+"\r\n") }
+		reply("220 localhost ready")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				messages <- data.String()
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+func TestMail(t *testing.T) {
+	assert.ErrorIs(t, SMTPConfig{}.Mail([]string{"ops@example.com"}, "s", "t"), ErrNoSMTP)
+
+	addr, messages := fakeSMTP(t)
+	c := SMTPConfig{Addr: addr, From: "peervault@example.com"}
+	err := c.Mail([]string{"ops@example.com"}, "Weekly report", "Attached.\nBye.",
+		Attachment{Filename: "report.csv", ContentType: "text/csv", Data: []byte("key,reads\na.txt,1\n")})
+	require.NoError(t, err)
+
+	msg, err := mail.ReadMessage(strings.NewReader(<-messages))
+	require.NoError(t, err)
+	assert.Equal(t, "Weekly report", msg.Header.Get("Subject"))
+	assert.Equal(t, "ops@example.com", msg.Header.Get("To"))
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	text, err := parts.NextPart()
+	require.NoError(t, err)
+	body, _ := io.ReadAll(text)
+	assert.Equal(t, "Attached.\r\nBye.\r\n", string(body))
+	attachment, err := parts.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "report.csv", attachment.FileName())
+	assert.Equal(t, "text/csv", attachment.Header.Get("Content-Type"))
+}
+
+func TestPost(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		if r.URL.Path == "/fail" {
+			http.Error(w, "nope", http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	err := Post(context.Background(), srv.Client(), srv.URL+"/ok", "application/json", http.Header{"X-Test": {"1"}}, []byte("{}"))
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", got.Header.Get("Content-Type"))
+	assert.Equal(t, "1", got.Header.Get("X-Test"))
+
+	err = Post(context.Background(), srv.Client(), srv.URL+"/fail", "text/plain", nil, nil)
+	assert.EqualError(t, err, "webhook returned 502 Bad Gateway")
+}
+
+func TestQueuedWebhooks(t *testing.T) {
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[r.URL.Path]++
+		switch {
+		case r.URL.Path == "/gone":
+			http.Error(w, "gone", http.StatusGone)
+		case attempts[r.URL.Path] < 3:
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		default:
+			data, _ := io.ReadAll(r.Body)
+			body = string(data)
+		}
+	}))
+	defer srv.Close()
+
+	q := queue.New(queue.Options{Backoff: time.Millisecond})
+	require.NoError(t, HandleWebhooks(q, srv.Client()))
+	q.Start()
+	defer q.Stop()
+	require.NoError(t, Deliver(context.Background(), q, nil, Webhook{URL: srv.URL + "/flaky", ContentType: "text/plain", Body: []byte("hello")}))
+	require.NoError(t, Deliver(context.Background(), q, nil, Webhook{URL: srv.URL + "/gone", ContentType: "text/plain"}))
+
+	require.Eventually(t, func() bool {
+		st := q.Stats()
+		return st.Pending == 0 && st.Running == 0
+	}, 5*time.Second, time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, map[string]int{"/flaky": 3, "/gone": 1}, attempts, "rejected deliveries are not retried")
+	dead := q.List(queue.Dead, WebhookJob)
+	require.Len(t, dead, 1)
+	assert.Contains(t, dead[0].LastError, "410 Gone")
+}
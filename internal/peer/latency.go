@@ -0,0 +1,301 @@
+package peer
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/dto"
+)
+
+// DefaultLatencyProbeInterval is how often each peer is probed by default
+const DefaultLatencyProbeInterval = 10 * time.Second
+
+// Round trip times are smoothed like the TCP retransmission timer
+// (RFC 6298): rttAlpha weighs new samples into the average, rttBeta into
+// the mean deviation reported as jitter. Loss is smoothed with rttAlpha.
+const (
+	rttAlpha = 0.125
+	rttBeta  = 0.25
+)
+
+// LatencyProberOpts configures a LatencyProber
+type LatencyProberOpts struct {
+	// ID is the node ID of this node
+	ID string
+	// Addr is the listen address of this node, shared with peers
+	Addr string
+	// Interval between probes of each peer
+	Interval time.Duration
+	// Timeout after which an unanswered probe counts as lost; defaults to
+	// the interval
+	Timeout time.Duration
+	// Peers returns the addresses of the connected peers
+	Peers func() []string
+	// Send delivers a probe to the peer at addr
+	Send func(addr string, probe dto.LatencyProbe) error
+}
+
+// LatencyProber periodically measures the round trip time to every
+// connected peer. Replies carry the measurements of the responder, so a
+// node also knows the latencies between its peers and can assemble the
+// latency matrix of its neighbourhood; in a fully meshed cluster that is
+// the whole cluster.
+type LatencyProber struct {
+	opts   LatencyProberOpts
+	mu     sync.RWMutex
+	seq    uint64
+	links  map[string]*latencyLink // by peer address
+	remote map[string]remoteLinks  // by node ID
+	now    func() time.Time
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// latencyLink is the state of the link to one peer
+type latencyLink struct {
+	id          string // Node ID of the peer once it has told us
+	pending     uint64 // Seq of the unanswered probe, 0 if none
+	pendingSent time.Time
+	srtt        time.Duration
+	rttvar      time.Duration
+	minRTT      time.Duration
+	loss        float64
+	samples     uint64
+	updated     time.Time
+}
+
+// remoteLinks are the links a peer reported in its last reply
+type remoteLinks struct {
+	addr     string
+	links    []dto.LatencyLink
+	received time.Time
+}
+
+// NewLatencyProber creates a latency prober; Start begins probing
+func NewLatencyProber(opts LatencyProberOpts) *LatencyProber {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultLatencyProbeInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = opts.Interval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &LatencyProber{
+		opts:   opts,
+		links:  make(map[string]*latencyLink),
+		remote: make(map[string]remoteLinks),
+		now:    time.Now,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Start probes all peers every interval until Stop is called
+func (p *LatencyProber) Start() {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.probe()
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops probing
+func (p *LatencyProber) Stop() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+// probe sends one probe to every peer that has no probe outstanding, and
+// counts the outstanding probes that timed out as lost
+func (p *LatencyProber) probe() {
+	now := p.now()
+	addrs := p.opts.Peers()
+	probes := make(map[string]dto.LatencyProbe, len(addrs))
+
+	p.mu.Lock()
+	connected := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		connected[addr] = true
+		l, ok := p.links[addr]
+		if !ok {
+			l = &latencyLink{}
+			p.links[addr] = l
+		}
+		if l.pending != 0 {
+			if now.Sub(l.pendingSent) < p.opts.Timeout {
+				continue
+			}
+			l.loss += rttAlpha * (1 - l.loss)
+		}
+		p.seq++
+		l.pending, l.pendingSent = p.seq, now
+		probes[addr] = dto.LatencyProbe{ID: p.opts.ID, Seq: p.seq}
+	}
+	for addr, l := range p.links {
+		if !connected[addr] {
+			delete(p.links, addr)
+			delete(p.remote, l.id)
+		}
+	}
+	p.mu.Unlock()
+
+	for addr, probe := range probes {
+		// A probe that cannot be sent is counted as lost once it times out
+		if err := p.opts.Send(addr, probe); err != nil {
+			slog.Debug("failed to send latency probe", "peer", addr, "error", err)
+		}
+	}
+}
+
+// HandleProbe answers a probe from the peer at addr
+func (p *LatencyProber) HandleProbe(addr string, probe dto.LatencyProbe) dto.LatencyReply {
+	p.mu.Lock()
+	if l, ok := p.links[addr]; ok && probe.ID != "" {
+		l.id = probe.ID
+	}
+	p.mu.Unlock()
+
+	return dto.LatencyReply{ID: p.opts.ID, Addr: p.opts.Addr, Seq: probe.Seq, Links: p.localLinks()}
+}
+
+// HandleReply records the answer to a probe sent to the peer at addr. The
+// round trip is timed on this node's clock only, so the clocks of the two
+// nodes need not agree.
+func (p *LatencyProber) HandleReply(addr string, reply dto.LatencyReply) {
+	now := p.now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	l, ok := p.links[addr]
+	if !ok {
+		// The peer disconnected since the probe was sent
+		return
+	}
+	if reply.ID != "" {
+		l.id = reply.ID
+	}
+	// Late replies to timed out probes still carry the peer's links
+	if reply.Seq != 0 && reply.Seq == l.pending {
+		l.record(now.Sub(l.pendingSent), now)
+		l.pending = 0
+	}
+	if reply.ID != "" && reply.ID != p.opts.ID {
+		p.remote[reply.ID] = remoteLinks{addr: reply.Addr, links: reply.Links, received: now}
+	}
+}
+
+// record adds a round trip time sample
+func (l *latencyLink) record(rtt time.Duration, now time.Time) {
+	if l.samples == 0 {
+		l.srtt, l.rttvar, l.minRTT = rtt, rtt/2, rtt
+	} else {
+		l.rttvar = time.Duration((1-rttBeta)*float64(l.rttvar) + rttBeta*float64((l.srtt-rtt).Abs()))
+		l.srtt = time.Duration((1-rttAlpha)*float64(l.srtt) + rttAlpha*float64(rtt))
+		l.minRTT = min(l.minRTT, rtt)
+	}
+	l.loss -= rttAlpha * l.loss
+	l.samples++
+	l.updated = now
+}
+
+// localLinks returns the measured links of this node
+func (p *LatencyProber) localLinks() []dto.LatencyLink {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	links := make([]dto.LatencyLink, 0, len(p.links))
+	for addr, l := range p.links {
+		if l.samples == 0 {
+			continue
+		}
+		links = append(links, dto.LatencyLink{
+			To:      cmp.Or(l.id, addr),
+			Addr:    addr,
+			RTT:     int64(l.srtt),
+			MinRTT:  int64(l.minRTT),
+			Jitter:  int64(l.rttvar),
+			Loss:    l.loss,
+			Samples: l.samples,
+			Updated: l.updated.UnixNano(),
+		})
+	}
+	return links
+}
+
+// RTT returns the smoothed round trip time to a peer given by node ID or
+// address, and false if it has not been measured
+func (p *LatencyProber) RTT(peer string) (time.Duration, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if l, ok := p.links[peer]; ok && l.samples > 0 {
+		return l.srtt, true
+	}
+	for _, l := range p.links {
+		if l.id == peer && l.samples > 0 {
+			return l.srtt, true
+		}
+	}
+	return 0, false
+}
+
+// SortByLatency orders peers, given by node ID or address, nearest first.
+// Peers without a measurement keep their order after the measured ones,
+// so placement can fall back on its own preference.
+func (p *LatencyProber) SortByLatency(peers []string) {
+	rtts := make(map[string]time.Duration, len(peers))
+	for _, peer := range peers {
+		if rtt, ok := p.RTT(peer); ok {
+			rtts[peer] = rtt
+		}
+	}
+	slices.SortStableFunc(peers, func(a, b string) int {
+		ra, aok := rtts[a]
+		rb, bok := rtts[b]
+		switch {
+		case aok && bok:
+			return cmp.Compare(ra, rb)
+		case aok:
+			return -1
+		case bok:
+			return 1
+		}
+		return 0
+	})
+}
+
+// Topology assembles the latency matrix from this node's links and the
+// links its peers reported. Reports older than three intervals are left
+// out.
+func (p *LatencyProber) Topology() Topology {
+	local := p.localLinks()
+	now := p.now()
+
+	p.mu.RLock()
+	rows := map[string][]dto.LatencyLink{p.opts.ID: local}
+	addrs := map[string]string{p.opts.ID: p.opts.Addr}
+	for id, r := range p.remote {
+		if now.Sub(r.received) > 3*p.opts.Interval {
+			continue
+		}
+		rows[id] = r.links
+		addrs[id] = r.addr
+	}
+	p.mu.RUnlock()
+
+	return newTopology(p.opts.ID, rows, addrs, now)
+}
@@ -0,0 +1,192 @@
+package peer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/dto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// latencyNet connects probers in memory. Each node sees its peers at the
+// node's name and a probe takes the configured round trip time on a
+// shared fake clock.
+type latencyNet struct {
+	clock   time.Time
+	nodes   map[string]*LatencyProber
+	rtts    map[[2]string]time.Duration
+	blocked map[[2]string]bool
+}
+
+func newLatencyNet() *latencyNet {
+	return &latencyNet{
+		clock:   time.Unix(1700000000, 0),
+		nodes:   map[string]*LatencyProber{},
+		rtts:    map[[2]string]time.Duration{},
+		blocked: map[[2]string]bool{},
+	}
+}
+
+func (n *latencyNet) add(id string, peers ...string) *LatencyProber {
+	p := NewLatencyProber(LatencyProberOpts{
+		ID:       id,
+		Addr:     id + ":3000",
+		Interval: time.Second,
+		Peers:    func() []string { return peers },
+		Send: func(addr string, probe dto.LatencyProbe) error {
+			if n.blocked[[2]string{id, addr}] {
+				return errors.New("unreachable")
+			}
+			reply := n.nodes[addr].HandleProbe(id, probe)
+			start := n.clock
+			n.clock = n.clock.Add(n.rtts[[2]string{id, addr}])
+			n.nodes[id].HandleReply(addr, reply)
+			n.clock = start
+			return nil
+		},
+	})
+	p.now = func() time.Time { return n.clock }
+	n.nodes[id] = p
+	return p
+}
+
+func TestLatencyProberMeasuresRoundTrips(t *testing.T) {
+	n := newLatencyNet()
+	a := n.add("a", "b")
+	b := n.add("b", "a")
+	n.rtts[[2]string{"a", "b"}] = 20 * time.Millisecond
+	n.rtts[[2]string{"b", "a"}] = 30 * time.Millisecond
+
+	a.probe()
+	rtt, ok := a.RTT("b")
+	require.True(t, ok)
+	assert.Equal(t, 20*time.Millisecond, rtt)
+
+	// Smoothing moves the estimate an eighth of the way to a new sample
+	n.rtts[[2]string{"a", "b"}] = 100 * time.Millisecond
+	a.probe()
+	rtt, _ = a.RTT("b")
+	assert.Equal(t, 30*time.Millisecond, rtt)
+
+	// B learns A's link from the reply to its own probe
+	b.probe()
+	topology := b.Topology()
+	assert.Equal(t, "b", topology.Self)
+	require.Len(t, topology.Nodes, 2)
+	assert.Equal(t, TopologyNode{ID: "b", Addr: "b:3000", Self: true}, topology.Nodes[0])
+	assert.Equal(t, TopologyNode{ID: "a", Addr: "a:3000"}, topology.Nodes[1])
+	require.Len(t, topology.Links, 2)
+	assert.Equal(t, "a", topology.Links[0].From)
+	assert.Equal(t, 30.0, topology.Links[0].RTTMs)
+	assert.Equal(t, 20.0, topology.Links[0].MinRTTMs)
+	assert.Equal(t, uint64(2), topology.Links[0].Samples)
+
+	// Rows are sources in node order: b, then a
+	require.NotNil(t, topology.Matrix[0][1])
+	assert.Equal(t, 30.0, *topology.Matrix[0][1])
+	assert.Equal(t, 30.0, *topology.Matrix[1][0])
+	assert.Equal(t, 0.0, *topology.Matrix[1][1])
+
+	rtt, ok = topology.RTT("a", "b")
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Millisecond, rtt)
+}
+
+func TestLatencyProberCountsLostProbes(t *testing.T) {
+	n := newLatencyNet()
+	a := n.add("a", "b")
+	n.add("b", "a")
+	n.blocked[[2]string{"a", "b"}] = true
+
+	a.probe()
+	assert.Zero(t, a.links["b"].loss, "the probe has not timed out yet")
+	for range 3 {
+		n.clock = n.clock.Add(time.Second)
+		a.probe()
+	}
+	assert.InDelta(t, 1-0.875*0.875*0.875, a.links["b"].loss, 1e-9)
+	_, ok := a.RTT("b")
+	assert.False(t, ok)
+
+	// The last probe times out too, then the answer to the next one
+	// brings the loss back down
+	delete(n.blocked, [2]string{"a", "b"})
+	n.clock = n.clock.Add(time.Second)
+	a.probe()
+	assert.InDelta(t, (1-0.875*0.875*0.875*0.875)*0.875, a.links["b"].loss, 1e-9)
+	_, ok = a.RTT("b")
+	assert.True(t, ok)
+
+	// Replies to probes that timed out are not timed
+	a.HandleReply("b", dto.LatencyReply{ID: "b", Seq: 1})
+	assert.Equal(t, uint64(1), a.links["b"].samples)
+}
+
+func TestLatencyProberForgetsPeers(t *testing.T) {
+	n := newLatencyNet()
+	peers := []string{"b", "c"}
+	a := n.add("a")
+	a.opts.Peers = func() []string { return peers }
+	n.add("b", "a")
+	n.add("c", "a")
+
+	a.probe()
+	assert.Len(t, a.Topology().Nodes, 3)
+
+	peers = []string{"b"}
+	a.probe()
+	_, ok := a.RTT("c")
+	assert.False(t, ok)
+	assert.Len(t, a.Topology().Nodes, 2)
+
+	// Reports of peers that stopped answering go stale
+	n.clock = n.clock.Add(4 * time.Second)
+	n.blocked[[2]string{"a", "b"}] = true
+	a.probe()
+	topology := a.Topology()
+	assert.Len(t, topology.Nodes, 2, "a still measured b")
+	for _, l := range topology.Links {
+		assert.Equal(t, "a", l.From)
+	}
+}
+
+func TestSortByLatency(t *testing.T) {
+	n := newLatencyNet()
+	a := n.add("a", "b", "c", "d")
+	n.add("b")
+	n.add("c")
+	n.add("d")
+	n.rtts[[2]string{"a", "b"}] = 50 * time.Millisecond
+	n.rtts[[2]string{"a", "c"}] = 5 * time.Millisecond
+	n.blocked[[2]string{"a", "d"}] = true
+	a.probe()
+
+	peers := []string{"unknown", "d", "b", "c"}
+	a.SortByLatency(peers)
+	assert.Equal(t, []string{"c", "b", "unknown", "d"}, peers)
+}
+
+func TestTopologyKeepsOneLinkPerPair(t *testing.T) {
+	rows := map[string][]dto.LatencyLink{
+		"a": {
+			{To: "b", Addr: "10.0.0.2:51234", RTT: int64(2 * time.Millisecond), Samples: 3},
+			{To: "b", Addr: "10.0.0.2:3000", RTT: int64(4 * time.Millisecond), Samples: 9},
+			{To: "10.0.0.3:3000", Addr: "10.0.0.3:3000", RTT: int64(time.Millisecond), Samples: 1},
+		},
+		"b": {},
+	}
+	addrs := map[string]string{"a": "10.0.0.1:3000", "b": "10.0.0.2:3000"}
+	topology := newTopology("a", rows, addrs, time.Now())
+
+	require.Len(t, topology.Links, 2)
+	assert.Equal(t, "10.0.0.3:3000", topology.Links[0].To)
+	assert.Equal(t, 4.0, topology.Links[1].RTTMs)
+	assert.Equal(t, []TopologyNode{
+		{ID: "a", Addr: "10.0.0.1:3000", Self: true},
+		{ID: "10.0.0.3:3000", Addr: "10.0.0.3:3000"},
+		{ID: "b", Addr: "10.0.0.2:3000"},
+	}, topology.Nodes)
+	assert.Nil(t, topology.Matrix[1][2], "unmeasured pairs are null")
+}
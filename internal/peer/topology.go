@@ -0,0 +1,123 @@
+package peer
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/dto"
+)
+
+// Topology is the latency map of the network as seen from one node
+type Topology struct {
+	// Self is the node ID of the node that assembled the map
+	Self string `json:"self"`
+	// Nodes are this node first, then the others by ID
+	Nodes []TopologyNode `json:"nodes"`
+	// Links are the measured links, by source then destination
+	Links []TopologyLink `json:"links"`
+	// Matrix holds the smoothed round trip time in milliseconds from
+	// Nodes[i] to Nodes[j], and null where it was not measured
+	Matrix      [][]*float64 `json:"matrix"`
+	GeneratedAt time.Time    `json:"generated_at"`
+}
+
+// TopologyNode is a node of the latency map. Nodes are known by their ID,
+// or by their address until they have answered a probe.
+type TopologyNode struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr,omitempty"`
+	Self bool   `json:"self,omitempty"`
+}
+
+// TopologyLink is the latency measured from one node to another
+type TopologyLink struct {
+	From     string  `json:"from"`
+	To       string  `json:"to"`
+	RTTMs    float64 `json:"rtt_ms"`
+	MinRTTMs float64 `json:"min_rtt_ms"`
+	JitterMs float64 `json:"jitter_ms"`
+	// Loss is the smoothed share of probes that went unanswered
+	Loss      float64   `json:"loss"`
+	Samples   uint64    `json:"samples"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// newTopology builds the map from the links measured by each node
+func newTopology(self string, rows map[string][]dto.LatencyLink, addrs map[string]string, now time.Time) Topology {
+	t := Topology{Self: self, Links: []TopologyLink{}, GeneratedAt: now}
+
+	// Nodes advertise their own address; others are known by the address
+	// their peers see them at
+	nodes := make(map[string]string, len(rows))
+	for from := range rows {
+		nodes[from] = addrs[from]
+	}
+	for from, links := range rows {
+		for _, l := range links {
+			if nodes[l.To] == "" {
+				nodes[l.To] = l.Addr
+			}
+			t.Links = append(t.Links, TopologyLink{
+				From:      from,
+				To:        l.To,
+				RTTMs:     ms(l.RTT),
+				MinRTTMs:  ms(l.MinRTT),
+				JitterMs:  ms(l.Jitter),
+				Loss:      l.Loss,
+				Samples:   l.Samples,
+				UpdatedAt: time.Unix(0, l.Updated),
+			})
+		}
+	}
+	// Two nodes that dialled each other are linked twice; keep the link
+	// with the most samples
+	slices.SortFunc(t.Links, func(a, b TopologyLink) int {
+		return cmp.Or(cmp.Compare(a.From, b.From), cmp.Compare(a.To, b.To), cmp.Compare(b.Samples, a.Samples))
+	})
+	t.Links = slices.CompactFunc(t.Links, func(a, b TopologyLink) bool {
+		return a.From == b.From && a.To == b.To
+	})
+
+	for id, addr := range nodes {
+		t.Nodes = append(t.Nodes, TopologyNode{ID: id, Addr: addr, Self: id == self})
+	}
+	slices.SortFunc(t.Nodes, func(a, b TopologyNode) int {
+		if a.Self != b.Self {
+			if a.Self {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	index := make(map[string]int, len(t.Nodes))
+	t.Matrix = make([][]*float64, len(t.Nodes))
+	for i, n := range t.Nodes {
+		index[n.ID] = i
+		t.Matrix[i] = make([]*float64, len(t.Nodes))
+		zero := 0.0
+		t.Matrix[i][i] = &zero
+	}
+	for _, l := range t.Links {
+		rtt := l.RTTMs
+		t.Matrix[index[l.From]][index[l.To]] = &rtt
+	}
+	return t
+}
+
+// RTT returns the round trip time measured from one node to another
+func (t Topology) RTT(from, to string) (time.Duration, bool) {
+	for _, l := range t.Links {
+		if l.From == from && l.To == to {
+			return time.Duration(l.RTTMs * float64(time.Millisecond)), true
+		}
+	}
+	return 0, false
+}
+
+// ms converts nanoseconds to milliseconds
+func ms(ns int64) float64 {
+	return float64(ns) / float64(time.Millisecond)
+}
@@ -0,0 +1,126 @@
+package plugins
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/pkg/plugin"
+)
+
+// ExternalConfig launches a plugin executable built with pkg/plugin
+type ExternalConfig struct {
+	Name    string
+	Path    string
+	Args    []string
+	Env     []string
+	Timeout time.Duration
+}
+
+// Launched external plugins by name
+var (
+	externalPlugins = make(map[string]*plugin.Client)
+	externalMutex   sync.RWMutex
+)
+
+// LaunchExternal starts the plugin of cfg and registers it under its name.
+// What the plugin writes to stderr is logged line by line.
+func LaunchExternal(cfg ExternalConfig, logger *slog.Logger) (*plugin.Client, error) {
+	externalMutex.Lock()
+	defer externalMutex.Unlock()
+	if _, exists := externalPlugins[cfg.Name]; exists {
+		return nil, fmt.Errorf("external plugin %s already launched", cfg.Name)
+	}
+	client, err := plugin.Launch(plugin.Config{
+		Name:    cfg.Name,
+		Path:    cfg.Path,
+		Args:    cfg.Args,
+		Env:     cfg.Env,
+		Timeout: cfg.Timeout,
+		Stderr:  &logWriter{logger: logger.With("plugin", cfg.Name)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	externalPlugins[client.Name()] = client
+	info := client.Info()
+	logger.Info("Launched plugin", "plugin", client.Name(), "version", info.Version, "capabilities", info.Capabilities)
+	return client, nil
+}
+
+// GetExternal retrieves a launched external plugin by name
+func GetExternal(name string) (*plugin.Client, error) {
+	externalMutex.RLock()
+	defer externalMutex.RUnlock()
+
+	client, exists := externalPlugins[name]
+	if !exists {
+		return nil, fmt.Errorf("external plugin %s not launched", name)
+	}
+	return client, nil
+}
+
+// ListExternal returns the launched external plugins sorted by name
+func ListExternal() []*plugin.Client {
+	externalMutex.RLock()
+	defer externalMutex.RUnlock()
+
+	clients := make([]*plugin.Client, 0, len(externalPlugins))
+	for _, client := range externalPlugins {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Name() < clients[j].Name() })
+	return clients
+}
+
+// StopExternal stops and unregisters every launched external plugin
+func StopExternal() error {
+	externalMutex.Lock()
+	defer externalMutex.Unlock()
+
+	var errs []error
+	for name, client := range externalPlugins {
+		if err := client.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop plugin %s: %w", name, err))
+		}
+		delete(externalPlugins, name)
+	}
+	return errors.Join(errs...)
+}
+
+// maxLogLine is the longest line of a plugin's stderr kept in one log
+// record
+const maxLogLine = 64 << 10
+
+// logWriter logs each line a plugin writes to stderr
+type logWriter struct {
+	logger *slog.Logger
+	mu     sync.Mutex
+	buf    []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if line := bytes.TrimSpace(w.buf[:i]); len(line) > 0 {
+			w.logger.Info(string(line))
+		}
+		w.buf = w.buf[i+1:]
+	}
+	// A plugin that never ends its lines is logged in pieces
+	if len(w.buf) > maxLogLine {
+		w.logger.Info(string(w.buf))
+		w.buf = nil
+	}
+	return len(p), nil
+}
@@ -0,0 +1,195 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHistorySize is the number of recent decisions an Engine keeps
+const DefaultHistorySize = 1000
+
+// Decision is the outcome of evaluating the rules on an operation
+type Decision struct {
+	Time      time.Time `json:"time"`
+	Operation Operation `json:"op"`
+	Key       string    `json:"key"`
+	Tenant    string    `json:"tenant,omitempty"`
+	// Peer is the address of the peer a file was replicated to
+	Peer    string   `json:"peer,omitempty"`
+	Allowed bool     `json:"allowed"`
+	Denials []Denial `json:"denials,omitempty"`
+	// DryRun decisions were logged but not enforced
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// Err returns ErrDenied wrapped with the denials, nil for allowed and
+// dry-run decisions
+func (d *Decision) Err() error {
+	if d.Allowed || d.DryRun {
+		return nil
+	}
+	messages := make([]string, len(d.Denials))
+	for i, denial := range d.Denials {
+		messages[i] = denial.Message
+	}
+	return fmt.Errorf("%w: %s", ErrDenied, strings.Join(messages, "; "))
+}
+
+// Options configures an Engine
+type Options struct {
+	Policy Policy
+	// DryRun logs the decisions without denying anything, to try a policy
+	// on live traffic
+	DryRun bool
+	// LogPath appends every decision to a file as a JSON line; empty keeps
+	// them in memory only
+	LogPath string
+	// HistorySize is the number of recent decisions kept in memory; zero
+	// uses DefaultHistorySize
+	HistorySize int
+	// Region is the region of this node, and Regions the regions of its
+	// peers by node ID or address; rules read them as node.region and
+	// peer.region
+	Region  string
+	Regions map[string]string
+}
+
+// Engine evaluates a policy on the operations of a node and logs its
+// decisions
+type Engine struct {
+	opts     Options
+	programs []program
+
+	mu     sync.Mutex
+	log    *os.File
+	recent []Decision
+	next   int
+}
+
+// New compiles the policy of opts
+func New(opts Options) (*Engine, error) {
+	programs, err := compile(opts.Policy)
+	if err != nil {
+		return nil, err
+	}
+	if opts.HistorySize <= 0 {
+		opts.HistorySize = DefaultHistorySize
+	}
+	e := &Engine{opts: opts, programs: programs}
+	if opts.LogPath != "" {
+		if err := os.MkdirAll(filepath.Dir(opts.LogPath), 0755); err != nil {
+			return nil, err
+		}
+		if e.log, err = os.OpenFile(opts.LogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return nil, fmt.Errorf("policy: failed to open decision log: %w", err)
+		}
+	}
+	return e, nil
+}
+
+// Policy returns the rules the engine evaluates
+func (e *Engine) Policy() Policy { return e.opts.Policy }
+
+// DryRun reports whether the engine only logs its decisions
+func (e *Engine) DryRun() bool { return e.opts.DryRun }
+
+// Region returns the region the policy assigns to a node ID or address
+func (e *Engine) Region(idOrAddr ...string) string {
+	for _, k := range idOrAddr {
+		if region, ok := e.opts.Regions[k]; ok && k != "" {
+			return region
+		}
+	}
+	return ""
+}
+
+// Evaluate evaluates the rules on in, filling in the regions of the node
+// and the peer, and logs the decision
+func (e *Engine) Evaluate(in Input) Decision {
+	in.Node.Region = e.opts.Region
+	if in.Peer.Region == "" {
+		in.Peer.Region = e.Region(in.Peer.ID, in.Peer.Addr)
+	}
+	denials := evaluate(e.programs, in)
+	d := Decision{
+		Time:      time.Now().UTC(),
+		Operation: in.Operation,
+		Key:       in.Key,
+		Tenant:    in.Tenant,
+		Peer:      in.Peer.Addr,
+		Allowed:   len(denials) == 0,
+		Denials:   denials,
+		DryRun:    e.opts.DryRun,
+	}
+	e.record(d)
+	return d
+}
+
+// Check evaluates the rules on in and returns the error denying it
+func (e *Engine) Check(in Input) error {
+	d := e.Evaluate(in)
+	return d.Err()
+}
+
+func (e *Engine) record(d Decision) {
+	if !d.Allowed {
+		slog.Warn("policy denied operation", "op", d.Operation, "key", d.Key, "peer", d.Peer, "denials", d.Denials, "dry_run", d.DryRun)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.recent) < e.opts.HistorySize {
+		e.recent = append(e.recent, d)
+	} else {
+		e.recent[e.next] = d
+	}
+	e.next = (e.next + 1) % e.opts.HistorySize
+
+	if e.log == nil {
+		return
+	}
+	line, err := json.Marshal(d)
+	if err != nil {
+		return
+	}
+	if _, err := e.log.Write(append(line, '\n')); err != nil {
+		slog.Warn("failed to log policy decision", "error", err)
+	}
+}
+
+// Decisions returns up to limit of the most recent decisions, newest
+// first; denied only keeps denials
+func (e *Engine) Decisions(limit int, denied bool) []Decision {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var out []Decision
+	for i := 1; i <= len(e.recent); i++ {
+		d := e.recent[(e.next-i+len(e.recent))%len(e.recent)]
+		if denied && d.Allowed {
+			continue
+		}
+		out = append(out, d)
+		if limit > 0 && len(out) == limit {
+			break
+		}
+	}
+	return out
+}
+
+// Close closes the decision log
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.log == nil {
+		return nil
+	}
+	err := e.log.Close()
+	e.log = nil
+	return err
+}
@@ -0,0 +1,315 @@
+// Package policy evaluates operator-defined rules on the operations of a
+// node. Rules are CEL expressions describing what to deny, e.g.
+//
+//	size > 100 * 1024 * 1024 && tenant == "free"
+//
+// and are evaluated when a file is stored, replicated to a peer, shared, or
+// copied or moved to another key.
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/ext"
+	"gopkg.in/yaml.v3"
+)
+
+// Operation is what a rule is evaluated on
+type Operation string
+
+const (
+	// OpStore stores a file on the node
+	OpStore Operation = "store"
+	// OpReplicate copies a stored file to a peer
+	OpReplicate Operation = "replicate"
+	// OpShare creates a share link to a file
+	OpShare Operation = "share"
+	// OpCopy copies a file to another key, possibly of another tenant
+	OpCopy Operation = "copy"
+	// OpMove moves a file to another key, possibly of another tenant
+	OpMove Operation = "move"
+)
+
+// Operations are every operation rules can apply to
+var Operations = []Operation{OpStore, OpReplicate, OpShare, OpCopy, OpMove}
+
+// ErrDenied is returned for operations a rule denied
+var ErrDenied = errors.New("policy: denied")
+
+// Rule denies the operations for which its expression holds
+type Rule struct {
+	ID          string `json:"id" yaml:"id"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Disabled    bool   `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	// Operations the rule applies to; every operation when empty
+	Operations []Operation `json:"operations,omitempty" yaml:"operations,omitempty"`
+	// Deny is a CEL expression; the operation is denied when it is true
+	Deny string `json:"deny" yaml:"deny"`
+	// Message explains a denial to the caller; defaults to the rule ID
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+// applies reports whether the rule is evaluated on op
+func (r *Rule) applies(op Operation) bool {
+	if r.Disabled {
+		return false
+	}
+	if len(r.Operations) == 0 {
+		return true
+	}
+	for _, o := range r.Operations {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy is a set of rules
+type Policy struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// Validate checks the rules and compiles their expressions
+func (p *Policy) Validate() error {
+	_, err := compile(*p)
+	return err
+}
+
+// LoadPolicy reads a policy from a YAML or JSON file, which holds either
+// {"rules": [...]} or a bare list of rules
+func LoadPolicy(path string) (Policy, error) {
+	var p Policy
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return p, err
+	}
+	unmarshal := yaml.Unmarshal
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		unmarshal = json.Unmarshal
+	}
+	if err := unmarshal(data, &p); err != nil {
+		if err := unmarshal(data, &p.Rules); err != nil {
+			return p, fmt.Errorf("policy: invalid policy %s: %w", path, err)
+		}
+	}
+	return p, p.Validate()
+}
+
+// Input is an operation as rules see it. Each field is a CEL variable of
+// the same name in snake case; peer, node, share and source are maps.
+type Input struct {
+	Operation   Operation         `json:"op"`
+	Key         string            `json:"key"`
+	Size        int64             `json:"size"`
+	ContentType string            `json:"content_type,omitempty"`
+	Tenant      string            `json:"tenant,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	// Peer is the peer a file is replicated to
+	Peer Peer `json:"peer"`
+	// Node is the node evaluating the rules
+	Node Peer `json:"node"`
+	// Share describes the link being created
+	Share Share `json:"share"`
+	// Source is the file being copied or moved; Key and Tenant are those of
+	// the destination
+	Source Source `json:"source"`
+}
+
+// Peer is a node, with the region the policy assigns it
+type Peer struct {
+	ID     string `json:"id,omitempty"`
+	Addr   string `json:"addr,omitempty"`
+	Region string `json:"region,omitempty"`
+}
+
+// Share is a share link being created
+type Share struct {
+	TTLSeconds   int64  `json:"ttl_seconds,omitempty"`
+	MaxDownloads int    `json:"max_downloads,omitempty"`
+	Password     bool   `json:"password,omitempty"`
+	CreatedBy    string `json:"created_by,omitempty"`
+}
+
+// Source is the file a copy or move reads
+type Source struct {
+	Key    string `json:"key,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// activation returns the CEL variables of the input
+func (in *Input) activation() map[string]any {
+	tags := in.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	meta := in.Metadata
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	return map[string]any{
+		"op":           string(in.Operation),
+		"key":          in.Key,
+		"size":         in.Size,
+		"content_type": in.ContentType,
+		"tenant":       in.Tenant,
+		"tags":         tags,
+		"metadata":     meta,
+		"peer":         map[string]string{"id": in.Peer.ID, "addr": in.Peer.Addr, "region": in.Peer.Region},
+		"node":         map[string]string{"id": in.Node.ID, "addr": in.Node.Addr, "region": in.Node.Region},
+		"share": map[string]any{
+			"ttl_seconds":   in.Share.TTLSeconds,
+			"max_downloads": int64(in.Share.MaxDownloads),
+			"password":      in.Share.Password,
+			"created_by":    in.Share.CreatedBy,
+		},
+		"source": map[string]string{"key": in.Source.Key, "tenant": in.Source.Tenant},
+	}
+}
+
+// Denial is a rule that denied an operation
+type Denial struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// program is a compiled rule
+type program struct {
+	rule Rule
+	prg  cel.Program
+}
+
+// newEnv declares the variables and functions rules can use
+func newEnv() (*cel.Env, error) {
+	stringMap := cel.MapType(cel.StringType, cel.StringType)
+	return cel.NewEnv(
+		ext.Strings(),
+		cel.Variable("op", cel.StringType),
+		cel.Variable("key", cel.StringType),
+		cel.Variable("size", cel.IntType),
+		cel.Variable("content_type", cel.StringType),
+		cel.Variable("tenant", cel.StringType),
+		cel.Variable("tags", cel.ListType(cel.StringType)),
+		cel.Variable("metadata", stringMap),
+		cel.Variable("peer", stringMap),
+		cel.Variable("node", stringMap),
+		cel.Variable("share", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("source", stringMap),
+		// inCIDR(addr, cidr) reports whether an address, with or without a
+		// port, is in a network
+		cel.Function("inCIDR",
+			cel.Overload("in_cidr_string_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+				cel.BinaryBinding(inCIDR))),
+	)
+}
+
+func inCIDR(addr, cidr ref.Val) ref.Val {
+	prefix, err := netip.ParsePrefix(cidr.Value().(string))
+	if err != nil {
+		return types.NewErr("inCIDR: %v", err)
+	}
+	s := addr.Value().(string)
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return types.Bool(prefix.Contains(ap.Addr().Unmap()))
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return types.False
+	}
+	return types.Bool(prefix.Contains(ip.Unmap()))
+}
+
+// compile checks the rules of p and compiles their expressions
+func compile(p Policy) ([]program, error) {
+	env, err := newEnv()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{}, len(p.Rules))
+	programs := make([]program, 0, len(p.Rules))
+	for i, r := range p.Rules {
+		if r.ID == "" {
+			return nil, fmt.Errorf("policy: rule %d has no id", i)
+		}
+		if _, dup := seen[r.ID]; dup {
+			return nil, fmt.Errorf("policy: duplicate rule id %q", r.ID)
+		}
+		seen[r.ID] = struct{}{}
+		for _, op := range r.Operations {
+			if !validOperation(op) {
+				return nil, fmt.Errorf("policy: rule %q has unknown operation %q", r.ID, op)
+			}
+		}
+		if strings.TrimSpace(r.Deny) == "" {
+			return nil, fmt.Errorf("policy: rule %q has no deny expression", r.ID)
+		}
+
+		ast, iss := env.Compile(r.Deny)
+		if iss.Err() != nil {
+			return nil, fmt.Errorf("policy: rule %q: %w", r.ID, iss.Err())
+		}
+		if ast.OutputType() != cel.BoolType {
+			return nil, fmt.Errorf("policy: rule %q: deny must be a boolean, not %s", r.ID, ast.OutputType())
+		}
+		prg, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("policy: rule %q: %w", r.ID, err)
+		}
+		programs = append(programs, program{rule: r, prg: prg})
+	}
+	return programs, nil
+}
+
+func validOperation(op Operation) bool {
+	for _, o := range Operations {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate runs the rules applying to in. A rule that fails to evaluate,
+// e.g. reading a missing metadata entry without has(), denies.
+func evaluate(programs []program, in Input) []Denial {
+	var denials []Denial
+	vars := in.activation()
+	for _, p := range programs {
+		if !p.rule.applies(in.Operation) {
+			continue
+		}
+		message := p.rule.Message
+		if message == "" {
+			message = "denied by rule " + p.rule.ID
+		}
+		out, _, err := p.prg.Eval(vars)
+		if err != nil {
+			denials = append(denials, Denial{Rule: p.rule.ID, Message: fmt.Sprintf("rule failed: %v", err)})
+			continue
+		}
+		if deny, ok := out.Value().(bool); ok && deny {
+			denials = append(denials, Denial{Rule: p.rule.ID, Message: message})
+		}
+	}
+	return denials
+}
+
+// Evaluate evaluates a policy on one operation without an Engine, e.g. to
+// try rules out before deploying them
+func Evaluate(p Policy, in Input) ([]Denial, error) {
+	programs, err := compile(p)
+	if err != nil {
+		return nil, err
+	}
+	return evaluate(programs, in), nil
+}
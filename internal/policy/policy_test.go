@@ -0,0 +1,150 @@
+package policy
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var rules = Policy{Rules: []Rule{
+	{ID: "free-tier-size", Operations: []Operation{OpStore}, Deny: `tenant == "free" && size > 1024`, Message: "free tenants store files up to 1 KiB"},
+	{ID: "no-executables", Operations: []Operation{OpStore}, Deny: `content_type in ["application/x-msdownload", "application/x-executable"]`},
+	{ID: "key-names", Operations: []Operation{OpStore}, Deny: `!key.matches("^[a-z0-9/._-]+$")`, Message: "keys are lowercase"},
+	{ID: "eu-stays-in-eu", Operations: []Operation{OpReplicate}, Deny: `has(metadata.residency) && metadata.residency == "eu" && peer.region != "eu"`},
+	{ID: "lan-only", Operations: []Operation{OpReplicate}, Deny: `!inCIDR(peer.addr, "10.0.0.0/8")`},
+	{ID: "shares-expire", Operations: []Operation{OpShare}, Deny: `share.ttl_seconds == 0 || share.ttl_seconds > 7 * 86400`},
+	{ID: "same-tenant", Operations: []Operation{OpCopy, OpMove}, Deny: `source.tenant != tenant && tenant != "shared"`},
+	{ID: "off", Disabled: true, Deny: `true`},
+}}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, rules.Validate())
+
+	cases := map[string]Policy{
+		"missing id":        {Rules: []Rule{{Deny: "true"}}},
+		"duplicate id":      {Rules: []Rule{{ID: "a", Deny: "true"}, {ID: "a", Deny: "false"}}},
+		"no expression":     {Rules: []Rule{{ID: "a"}}},
+		"syntax error":      {Rules: []Rule{{ID: "a", Deny: "size >"}}},
+		"unknown variable":  {Rules: []Rule{{ID: "a", Deny: `owner == "x"`}}},
+		"not a boolean":     {Rules: []Rule{{ID: "a", Deny: "size + 1"}}},
+		"unknown operation": {Rules: []Rule{{ID: "a", Operations: []Operation{"delete"}, Deny: "true"}}},
+	}
+	for name, p := range cases {
+		assert.Error(t, p.Validate(), name)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	denials, err := Evaluate(rules, Input{Operation: OpStore, Key: "docs/a.txt", Size: 2048, Tenant: "paid", ContentType: "text/plain"})
+	require.NoError(t, err)
+	assert.Empty(t, denials)
+
+	denials, err = Evaluate(rules, Input{Operation: OpStore, Key: "Setup.EXE", Size: 2048, Tenant: "free", ContentType: "application/x-msdownload"})
+	require.NoError(t, err)
+	assert.Equal(t, []Denial{
+		{Rule: "free-tier-size", Message: "free tenants store files up to 1 KiB"},
+		{Rule: "no-executables", Message: "denied by rule no-executables"},
+		{Rule: "key-names", Message: "keys are lowercase"},
+	}, denials)
+
+	eu := map[string]string{"residency": "eu"}
+	denials, _ = Evaluate(rules, Input{Operation: OpReplicate, Key: "a", Metadata: eu, Peer: Peer{Addr: "10.1.2.3:3000", Region: "us"}})
+	assert.Equal(t, []string{"eu-stays-in-eu"}, ruleIDs(denials))
+	denials, _ = Evaluate(rules, Input{Operation: OpReplicate, Key: "a", Metadata: eu, Peer: Peer{Addr: "192.168.1.1:3000", Region: "eu"}})
+	assert.Equal(t, []string{"lan-only"}, ruleIDs(denials))
+	denials, _ = Evaluate(rules, Input{Operation: OpReplicate, Key: "a", Peer: Peer{Addr: "10.1.2.3:3000"}})
+	assert.Empty(t, denials)
+
+	denials, _ = Evaluate(rules, Input{Operation: OpShare, Key: "a", Share: Share{TTLSeconds: 3600}})
+	assert.Empty(t, denials)
+	denials, _ = Evaluate(rules, Input{Operation: OpShare, Key: "a"})
+	assert.Equal(t, []string{"shares-expire"}, ruleIDs(denials))
+
+	denials, _ = Evaluate(rules, Input{Operation: OpCopy, Key: "b", Tenant: "acme", Source: Source{Key: "a", Tenant: "acme"}})
+	assert.Empty(t, denials)
+	denials, _ = Evaluate(rules, Input{Operation: OpMove, Key: "b", Tenant: "globex", Source: Source{Key: "a", Tenant: "acme"}})
+	assert.Equal(t, []string{"same-tenant"}, ruleIDs(denials))
+	denials, _ = Evaluate(rules, Input{Operation: OpCopy, Key: "b", Tenant: "shared", Source: Source{Key: "a", Tenant: "acme"}})
+	assert.Empty(t, denials)
+}
+
+func TestEvaluate_FailingRuleDenies(t *testing.T) {
+	denials, err := Evaluate(Policy{Rules: []Rule{{ID: "owner", Deny: `metadata.owner == ""`}}}, Input{Operation: OpStore, Key: "a"})
+	require.NoError(t, err)
+	require.Len(t, denials, 1)
+	assert.Contains(t, denials[0].Message, "rule failed")
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "policy.yaml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte(`rules:
+  - id: no-tmp
+    operations: [store]
+    deny: key.startsWith("tmp/")
+`), 0644))
+	p, err := LoadPolicy(yamlPath)
+	require.NoError(t, err)
+	assert.Equal(t, []Rule{{ID: "no-tmp", Operations: []Operation{OpStore}, Deny: `key.startsWith("tmp/")`}}, p.Rules)
+
+	jsonPath := filepath.Join(dir, "policy.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`[{"id": "big", "deny": "size > 10"}]`), 0644))
+	p, err = LoadPolicy(jsonPath)
+	require.NoError(t, err)
+	assert.Len(t, p.Rules, 1)
+
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`[{"id": "big", "deny": "size >"}]`), 0644))
+	_, err = LoadPolicy(jsonPath)
+	assert.Error(t, err)
+}
+
+func TestEngine(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "decisions.log")
+	e, err := New(Options{Policy: rules, LogPath: logPath, HistorySize: 2, Region: "eu", Regions: map[string]string{"10.1.2.3:3000": "us"}})
+	require.NoError(t, err)
+
+	assert.NoError(t, e.Check(Input{Operation: OpStore, Key: "a.txt", Size: 10}))
+	err = e.Check(Input{Operation: OpReplicate, Key: "a.txt", Metadata: map[string]string{"residency": "eu"}, Peer: Peer{Addr: "10.1.2.3:3000"}})
+	assert.ErrorIs(t, err, ErrDenied)
+	assert.NoError(t, e.Check(Input{Operation: OpReplicate, Key: "b.txt", Peer: Peer{Addr: "10.1.2.3:3000"}}))
+
+	recent := e.Decisions(0, false)
+	require.Len(t, recent, 2, "only HistorySize decisions are kept")
+	assert.Equal(t, "b.txt", recent[0].Key)
+	denied := e.Decisions(0, true)
+	require.Len(t, denied, 1)
+	assert.Equal(t, "10.1.2.3:3000", denied[0].Peer)
+	require.NoError(t, e.Close())
+
+	f, err := os.Open(logPath)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+	var logged []Decision
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var d Decision
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &d))
+		logged = append(logged, d)
+	}
+	require.Len(t, logged, 3)
+	assert.False(t, logged[1].Allowed)
+
+	dry, err := New(Options{Policy: rules, DryRun: true})
+	require.NoError(t, err)
+	d := dry.Evaluate(Input{Operation: OpStore, Key: "UPPER"})
+	assert.False(t, d.Allowed)
+	assert.NoError(t, d.Err(), "dry runs deny nothing")
+}
+
+func ruleIDs(denials []Denial) []string {
+	ids := make([]string, len(denials))
+	for i, d := range denials {
+		ids[i] = d.Rule
+	}
+	return ids
+}
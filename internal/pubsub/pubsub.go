@@ -0,0 +1,554 @@
+// Package pubsub keeps durable topics. Messages published to a durable
+// topic are appended to an append-only log stored in the cluster, so
+// consumers replay them from an offset, and consumer groups resume after
+// the last message they acknowledged, whichever protocol they consume
+// with. Messages a group has not acknowledged are delivered again when it
+// subscribes again, which makes delivery at least once.
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/streamlog"
+)
+
+// Prefix is the key prefix the objects of durable topics are stored under
+const Prefix = ".topics/"
+
+// DefaultCommitInterval is how often acknowledged offsets are stored
+const DefaultCommitInterval = 5 * time.Second
+
+// Resume starts a subscription after the last message its group
+// acknowledged, or at the end of the topic for new groups
+const Resume int64 = -1
+
+var (
+	// ErrNotDurable is returned for topics no durable prefix selects
+	ErrNotDurable = errors.New("pubsub: topic is not durable")
+	// ErrNotFound is returned for durable topics nothing was published to
+	ErrNotFound = errors.New("pubsub: topic not found")
+	// ErrNoGroup is returned for acknowledgments without a consumer group
+	ErrNoGroup = errors.New("pubsub: acknowledgments need a consumer group")
+)
+
+// Config selects the durable topics and how long their messages are kept
+type Config struct {
+	// Prefixes select the durable topics by the start of their name; ""
+	// makes every topic durable
+	Prefixes []string `yaml:"prefixes" json:"prefixes"`
+	// Log sets the segments and retention of the logs of topics
+	Log streamlog.Config `yaml:"log" json:"log"`
+	// CommitInterval is how often acknowledged offsets are stored;
+	// DefaultCommitInterval when zero. Offsets acknowledged since are
+	// lost in a crash, and their messages delivered again.
+	CommitInterval time.Duration `yaml:"commit_interval" json:"commit_interval"`
+}
+
+// Message is a message of a durable topic
+type Message struct {
+	Topic   string    `json:"topic"`
+	Offset  uint64    `json:"offset"`
+	Time    time.Time `json:"time"`
+	Payload []byte    `json:"payload,omitempty"`
+}
+
+// Info describes a durable topic
+type Info struct {
+	Topic string `json:"topic"`
+	// StartOffset is the oldest offset still stored, NextOffset the one the
+	// next message gets
+	StartOffset uint64 `json:"start_offset"`
+	NextOffset  uint64 `json:"next_offset"`
+	Messages    uint64 `json:"messages"`
+	Bytes       int64  `json:"bytes"`
+	// Groups holds the offset each consumer group resumes at
+	Groups map[string]uint64 `json:"groups,omitempty"`
+}
+
+var levelRE = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// logName returns the log of a durable topic. Topic levels are joined by
+// dots, so the levels of durable topics cannot contain dots themselves.
+func logName(topic string) (string, bool) {
+	for level := range strings.SplitSeq(topic, "/") {
+		if !levelRE.MatchString(level) {
+			return "", false
+		}
+	}
+	name := strings.ReplaceAll(topic, "/", ".")
+	return name, streamlog.ValidName(name)
+}
+
+func topicName(log string) string { return strings.ReplaceAll(log, ".", "/") }
+
+func groupsKey(name string) string { return Prefix + name + "/groups" }
+
+// prefixedStore keeps the logs of topics apart from the other logs of the
+// store
+type prefixedStore struct {
+	streamlog.Store
+}
+
+func (s prefixedStore) key(key string) string {
+	return Prefix + strings.TrimPrefix(key, streamlog.Prefix)
+}
+
+func (s prefixedStore) Has(key string) bool { return s.Store.Has(s.key(key)) }
+
+func (s prefixedStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return s.Store.Get(ctx, s.key(key))
+}
+
+func (s prefixedStore) Put(ctx context.Context, key string, data []byte) error {
+	return s.Store.Put(ctx, s.key(key), data)
+}
+
+func (s prefixedStore) Delete(ctx context.Context, key string) error {
+	return s.Store.Delete(ctx, s.key(key))
+}
+
+// groups holds the offsets the consumer groups of a topic resume at
+type groups struct {
+	offsets map[string]uint64
+	dirty   bool
+}
+
+// Topics publishes to and delivers the messages of durable topics. Each
+// topic should be published to through one Topics at a time, as its log
+// is.
+type Topics struct {
+	cfg   Config
+	store streamlog.Store
+	logs  *streamlog.Manager
+
+	mu      sync.Mutex
+	groups  map[string]*groups
+	changed map[string]chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// New creates the durable topics kept in store
+func New(cfg Config, store streamlog.Store) *Topics {
+	if cfg.CommitInterval <= 0 {
+		cfg.CommitInterval = DefaultCommitInterval
+	}
+	return &Topics{
+		cfg:     cfg,
+		store:   store,
+		logs:    streamlog.New(cfg.Log, prefixedStore{store}),
+		groups:  make(map[string]*groups),
+		changed: make(map[string]chan struct{}),
+	}
+}
+
+// Durable reports whether messages published to topic are kept
+func (t *Topics) Durable(topic string) bool {
+	if _, ok := logName(topic); !ok {
+		return false
+	}
+	return slices.ContainsFunc(t.cfg.Prefixes, func(prefix string) bool {
+		return strings.HasPrefix(topic, prefix)
+	})
+}
+
+func (t *Topics) name(topic string) (string, error) {
+	if !t.Durable(topic) {
+		return "", fmt.Errorf("%w: %s", ErrNotDurable, topic)
+	}
+	name, _ := logName(topic)
+	return name, nil
+}
+
+// signal returns the channel closed on the next publish to a topic. The
+// caller holds t.mu.
+func (t *Topics) signal(name string) chan struct{} {
+	ch, ok := t.changed[name]
+	if !ok {
+		ch = make(chan struct{})
+		t.changed[name] = ch
+	}
+	return ch
+}
+
+// Publish appends a message to a durable topic. Once it returns, the
+// message is stored and is delivered to every subscription of the topic.
+func (t *Topics) Publish(ctx context.Context, topic string, payload []byte) (Message, error) {
+	messages, err := t.PublishBatch(ctx, topic, payload)
+	if err != nil {
+		return Message{}, err
+	}
+	return messages[0], nil
+}
+
+// PublishBatch appends messages to a durable topic in one write, which
+// gives them consecutive offsets
+func (t *Topics) PublishBatch(ctx context.Context, topic string, payloads ...[]byte) ([]Message, error) {
+	name, err := t.name(topic)
+	if err != nil {
+		return nil, err
+	}
+	records, err := t.logs.Append(ctx, name, payloads...)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	if ch, ok := t.changed[name]; ok {
+		close(ch)
+		delete(t.changed, name)
+	}
+	t.mu.Unlock()
+	messages := make([]Message, len(records))
+	for i, r := range records {
+		messages[i] = Message{Topic: topic, Offset: r.Offset, Time: r.Time, Payload: r.Data}
+	}
+	return messages, nil
+}
+
+// Read returns up to limit messages of a topic from offset on
+func (t *Topics) Read(ctx context.Context, topic string, offset uint64, limit int) ([]Message, error) {
+	name, err := t.name(topic)
+	if err != nil {
+		return nil, err
+	}
+	records, err := t.logs.Read(ctx, name, streamlog.Query{Offset: offset, Limit: limit})
+	if errors.Is(err, streamlog.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]Message, len(records))
+	for i, r := range records {
+		messages[i] = Message{Topic: topic, Offset: r.Offset, Time: r.Time, Payload: r.Data}
+	}
+	return messages, nil
+}
+
+// loadGroups returns the offsets of the groups of a topic, reading them
+// from the store the first time. The caller holds t.mu.
+func (t *Topics) loadGroups(ctx context.Context, name string) (*groups, error) {
+	if g, ok := t.groups[name]; ok {
+		return g, nil
+	}
+	g := &groups{offsets: make(map[string]uint64)}
+	if key := groupsKey(name); t.store.Has(key) {
+		data, err := t.store.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &g.offsets); err != nil {
+			return nil, fmt.Errorf("pubsub: corrupt offsets of %s: %w", topicName(name), err)
+		}
+	}
+	t.groups[name] = g
+	return g, nil
+}
+
+// commit records that a group processed the messages of a topic before
+// offset. Offsets only move forward unless rewind is set.
+func (t *Topics) commit(ctx context.Context, name, group string, offset uint64, rewind bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	g, err := t.loadGroups(ctx, name)
+	if err != nil {
+		return err
+	}
+	if current, ok := g.offsets[group]; !ok || offset > current || (rewind && offset != current) {
+		g.offsets[group] = offset
+		g.dirty = true
+	}
+	return nil
+}
+
+// Ack acknowledges the messages of a topic up to offset for a group, which
+// resumes after them; consumers that do not keep a Subscription between
+// requests acknowledge this way. Acknowledgments are cumulative.
+func (t *Topics) Ack(ctx context.Context, topic, group string, offset uint64) error {
+	name, err := t.name(topic)
+	if err != nil {
+		return err
+	}
+	if group == "" {
+		return ErrNoGroup
+	}
+	return t.commit(ctx, name, group, offset+1, false)
+}
+
+// Commit sets the offset a group resumes a topic at. Unlike Ack it may
+// move the group back, to consume messages again.
+func (t *Topics) Commit(ctx context.Context, topic, group string, next uint64) error {
+	name, err := t.name(topic)
+	if err != nil {
+		return err
+	}
+	if group == "" {
+		return ErrNoGroup
+	}
+	return t.commit(ctx, name, group, next, true)
+}
+
+// Committed returns the offset a group resumes a topic at, false when the
+// group never consumed it
+func (t *Topics) Committed(ctx context.Context, topic, group string) (uint64, bool, error) {
+	name, err := t.name(topic)
+	if err != nil {
+		return 0, false, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	g, err := t.loadGroups(ctx, name)
+	if err != nil {
+		return 0, false, err
+	}
+	offset, ok := g.offsets[group]
+	return offset, ok, nil
+}
+
+// MessageAt returns the first message of a topic published at or after
+// when, false when there is none
+func (t *Topics) MessageAt(ctx context.Context, topic string, when time.Time) (Message, bool, error) {
+	name, err := t.name(topic)
+	if err != nil {
+		return Message{}, false, err
+	}
+	records, err := t.logs.Read(ctx, name, streamlog.Query{Since: when, Limit: 1})
+	if errors.Is(err, streamlog.ErrNotFound) {
+		return Message{}, false, nil
+	}
+	if err != nil || len(records) == 0 {
+		return Message{}, false, err
+	}
+	r := records[0]
+	return Message{Topic: topic, Offset: r.Offset, Time: r.Time, Payload: r.Data}, true, nil
+}
+
+// Flush stores the offsets acknowledged since the last flush
+func (t *Topics) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var errs []error
+	for name, g := range t.groups {
+		if !g.dirty {
+			continue
+		}
+		data, err := json.Marshal(g.offsets)
+		if err == nil {
+			err = t.store.Put(ctx, groupsKey(name), data)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		g.dirty = false
+	}
+	return errors.Join(errs...)
+}
+
+// Info describes a durable topic
+func (t *Topics) Info(ctx context.Context, topic string) (Info, error) {
+	name, err := t.name(topic)
+	if err != nil {
+		return Info{}, err
+	}
+	log, err := t.logs.Info(ctx, name)
+	if errors.Is(err, streamlog.ErrNotFound) {
+		return Info{}, fmt.Errorf("%w: %s", ErrNotFound, topic)
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	return t.info(ctx, log)
+}
+
+func (t *Topics) info(ctx context.Context, log streamlog.Info) (Info, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	g, err := t.loadGroups(ctx, log.Name)
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{
+		Topic:       topicName(log.Name),
+		StartOffset: log.StartOffset,
+		NextOffset:  log.NextOffset,
+		Messages:    log.Records,
+		Bytes:       log.Bytes,
+	}
+	if len(g.offsets) > 0 {
+		info.Groups = make(map[string]uint64, len(g.offsets))
+		for group, offset := range g.offsets {
+			info.Groups[group] = offset
+		}
+	}
+	return info, nil
+}
+
+// List describes every durable topic messages were published to
+func (t *Topics) List(ctx context.Context) ([]Info, error) {
+	logs, err := t.logs.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]Info, 0, len(logs))
+	for _, log := range logs {
+		info, err := t.info(ctx, log)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Subscribe starts delivering the messages of a durable topic from offset
+// on, or from where group resumes for Resume. Without a group nothing is
+// acknowledged, so every subscription starts at its own offset.
+func (t *Topics) Subscribe(ctx context.Context, topic, group string, offset int64) (*Subscription, error) {
+	name, err := t.name(topic)
+	if err != nil {
+		return nil, err
+	}
+	s := &Subscription{topics: t, topic: topic, name: name, group: group}
+	if offset >= 0 {
+		s.next = uint64(offset)
+		return s, nil
+	}
+
+	if group != "" {
+		t.mu.Lock()
+		g, err := t.loadGroups(ctx, name)
+		var committed uint64
+		var ok bool
+		if err == nil {
+			committed, ok = g.offsets[group]
+		}
+		t.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			s.next = committed
+			return s, nil
+		}
+	}
+	info, err := t.logs.Info(ctx, name)
+	switch {
+	case err == nil:
+		s.next = info.NextOffset
+	case !errors.Is(err, streamlog.ErrNotFound):
+		return nil, err
+	}
+	if group != "" {
+		// New groups start at the end even if they acknowledge nothing
+		if err := t.commit(ctx, name, group, s.next, false); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Start stores acknowledged offsets each CommitInterval and applies the
+// retention of topics until Stop is called
+func (t *Topics) Start() {
+	t.mu.Lock()
+	if t.stop != nil {
+		t.mu.Unlock()
+		return
+	}
+	t.stop = make(chan struct{})
+	t.done = make(chan struct{})
+	stop, done := t.stop, t.done
+	t.mu.Unlock()
+
+	t.logs.Start()
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(t.cfg.CommitInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				_ = t.Flush(context.Background())
+			}
+		}
+	}()
+}
+
+// Stop stops the background work and stores the acknowledged offsets
+func (t *Topics) Stop() {
+	t.mu.Lock()
+	stop, done := t.stop, t.done
+	t.stop, t.done = nil, nil
+	t.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	t.logs.Stop()
+	_ = t.Flush(context.Background())
+}
+
+// Subscription delivers the messages of a durable topic in order. Next is
+// called by one goroutine at a time; Ack may be called from any.
+type Subscription struct {
+	topics *Topics
+	topic  string
+	name   string
+	group  string
+	next   uint64
+}
+
+// Topic returns the topic of the subscription
+func (s *Subscription) Topic() string { return s.topic }
+
+// Group returns the consumer group of the subscription, empty without one
+func (s *Subscription) Group() string { return s.group }
+
+// Offset returns the offset of the next message Next returns
+func (s *Subscription) Offset() uint64 { return s.next }
+
+// Next returns up to limit messages, waiting for one to be published when
+// the subscription has delivered them all
+func (s *Subscription) Next(ctx context.Context, limit int) ([]Message, error) {
+	for {
+		// Taken before reading so a publish in between is not missed
+		s.topics.mu.Lock()
+		changed := s.topics.signal(s.name)
+		s.topics.mu.Unlock()
+
+		messages, err := s.topics.Read(ctx, s.topic, s.next, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(messages) > 0 {
+			s.next = messages[len(messages)-1].Offset + 1
+			return messages, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Ack acknowledges the messages of the subscription up to offset, so its
+// group resumes after them. Acknowledgments are cumulative.
+func (s *Subscription) Ack(ctx context.Context, offset uint64) error {
+	if s.group == "" {
+		return nil
+	}
+	return s.topics.commit(ctx, s.name, s.group, offset+1, false)
+}
@@ -0,0 +1,205 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore is a store in memory
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemStore() *memStore { return &memStore{objects: make(map[string][]byte)} }
+
+func (s *memStore) Has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.objects[key]
+	return ok
+}
+
+func (s *memStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return data, nil
+}
+
+func (s *memStore) Put(ctx context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	return nil
+}
+
+func (s *memStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *memStore) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func payloads(messages []Message) []string {
+	out := make([]string, len(messages))
+	for i, m := range messages {
+		out[i] = string(m.Payload)
+	}
+	return out
+}
+
+func TestDurable(t *testing.T) {
+	topics := New(Config{Prefixes: []string{"durable/", "audit"}}, newMemStore())
+
+	assert.True(t, topics.Durable("durable/sensors/temp"))
+	assert.True(t, topics.Durable("audit"))
+	assert.False(t, topics.Durable("live/sensors"))
+	assert.False(t, topics.Durable("durable/sensors/+"), "wildcards are not topics")
+	assert.False(t, topics.Durable("durable/v1.2"), "levels cannot contain dots")
+	assert.False(t, topics.Durable("durable//empty"))
+
+	_, err := topics.Publish(context.Background(), "live/sensors", []byte("x"))
+	assert.ErrorIs(t, err, ErrNotDurable)
+	_, err = topics.Info(context.Background(), "durable/none")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestPublishAndReplay(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	topics := New(Config{Prefixes: []string{""}}, store)
+
+	for i := range 3 {
+		msg, err := topics.Publish(ctx, "orders/created", fmt.Appendf(nil, "o%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(i), msg.Offset)
+	}
+	for _, key := range store.keys() {
+		assert.True(t, strings.HasPrefix(key, Prefix), "%s is kept apart from other logs", key)
+	}
+
+	sub, err := topics.Subscribe(ctx, "orders/created", "", 1)
+	require.NoError(t, err)
+	got, err := sub.Next(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"o1", "o2"}, payloads(got))
+	assert.Equal(t, "orders/created", got[0].Topic)
+
+	// Next waits for the next message
+	done := make(chan []Message, 1)
+	go func() {
+		got, _ := sub.Next(ctx, 10)
+		done <- got
+	}()
+	select {
+	case <-done:
+		t.Fatal("Next returned before a message was published")
+	case <-time.After(20 * time.Millisecond):
+	}
+	_, err = topics.Publish(ctx, "orders/created", []byte("o3"))
+	require.NoError(t, err)
+	select {
+	case got := <-done:
+		assert.Equal(t, []string{"o3"}, payloads(got))
+	case <-time.After(time.Second):
+		t.Fatal("Next did not return after the publish")
+	}
+
+	infos, err := topics.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "orders/created", infos[0].Topic)
+	assert.Equal(t, uint64(4), infos[0].Messages)
+}
+
+func TestGroupsResume(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	topics := New(Config{Prefixes: []string{"jobs"}}, store)
+	_, err := topics.Publish(ctx, "jobs", []byte("before"))
+	require.NoError(t, err)
+
+	// A new group starts at the end of the topic
+	sub, err := topics.Subscribe(ctx, "jobs", "workers", Resume)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), sub.Offset())
+	for _, p := range []string{"j1", "j2", "j3"} {
+		_, err := topics.Publish(ctx, "jobs", []byte(p))
+		require.NoError(t, err)
+	}
+	got, err := sub.Next(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, got, 3)
+	require.NoError(t, sub.Ack(ctx, got[0].Offset))
+
+	// Unacknowledged messages are delivered again
+	again, err := topics.Subscribe(ctx, "jobs", "workers", Resume)
+	require.NoError(t, err)
+	got, err = again.Next(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"j2", "j3"}, payloads(got))
+	require.NoError(t, again.Ack(ctx, got[1].Offset))
+	require.NoError(t, again.Ack(ctx, got[0].Offset), "acknowledgments are cumulative")
+
+	// Offsets survive a restart once flushed
+	require.NoError(t, topics.Flush(ctx))
+	restarted := New(Config{Prefixes: []string{"jobs"}}, store)
+	info, err := restarted.Info(ctx, "jobs")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint64{"workers": 4}, info.Groups)
+	resumed, err := restarted.Subscribe(ctx, "jobs", "workers", Resume)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), resumed.Offset())
+}
+
+func TestCommitAndLookup(t *testing.T) {
+	ctx := context.Background()
+	topics := New(Config{Prefixes: []string{"jobs"}}, newMemStore())
+	start := time.Now()
+	messages, err := topics.PublishBatch(ctx, "jobs", []byte("a"), []byte("b"), []byte("c"))
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{0, 1, 2}, []uint64{messages[0].Offset, messages[1].Offset, messages[2].Offset})
+
+	_, ok, err := topics.Committed(ctx, "jobs", "workers")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	require.NoError(t, topics.Commit(ctx, "jobs", "workers", 3))
+	require.NoError(t, topics.Ack(ctx, "jobs", "workers", 0), "acknowledgments do not rewind")
+	offset, ok, err := topics.Committed(ctx, "jobs", "workers")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(3), offset)
+	require.NoError(t, topics.Commit(ctx, "jobs", "workers", 1), "commits do")
+	offset, _, _ = topics.Committed(ctx, "jobs", "workers")
+	assert.Equal(t, uint64(1), offset)
+
+	m, ok, err := topics.MessageAt(ctx, "jobs", start)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "a", string(m.Payload))
+	_, ok, err = topics.MessageAt(ctx, "jobs", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,600 @@
+// Package queue runs the background work of a node, such as pushing
+// replicas peers missed, re-encrypting objects, rendering thumbnails and
+// delivering webhooks. Jobs persist until they succeed, so work is not lost
+// when the node crashes or restarts. Jobs with a higher priority run first.
+// Failed jobs are retried with exponential backoff until they run out of
+// attempts, then kept as dead letters that operators inspect, requeue or
+// delete.
+package queue
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultWorkers is how many jobs run at once
+	DefaultWorkers = 4
+	// DefaultMaxAttempts is how many times a job runs before it is dead
+	DefaultMaxAttempts = 8
+	// DefaultBackoff is how long the first retry of a job waits; each
+	// retry after it waits twice as long
+	DefaultBackoff = time.Second
+	// DefaultMaxBackoff caps the wait between retries
+	DefaultMaxBackoff = 10 * time.Minute
+	// DefaultDeadLetters is how many dead jobs are kept
+	DefaultDeadLetters = 1000
+)
+
+var (
+	// ErrInvalid is returned for jobs with a malformed kind or a payload
+	// that does not encode, and for jobs that cannot be requeued or deleted
+	// in their state
+	ErrInvalid = errors.New("queue: invalid job")
+	// ErrNotFound is returned for jobs that are not queued
+	ErrNotFound = errors.New("queue: job not found")
+	// ErrPermanent is wrapped by handlers failing in a way retrying cannot
+	// fix, which makes the job dead at once
+	ErrPermanent = errors.New("queue: permanent failure")
+)
+
+// validKind matches job kinds, such as "replicate" or "webhook"
+var validKind = regexp.MustCompile(`^[a-z][a-z0-9._-]{0,63}$`)
+
+// State is where a job is in its life
+type State string
+
+const (
+	// Pending jobs wait for a worker or for their next attempt
+	Pending State = "pending"
+	Running State = "running"
+	// Dead jobs failed permanently or ran out of attempts
+	Dead State = "dead"
+)
+
+// Job is queued work
+type Job struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	// Key deduplicates jobs: a job enqueued with the key of a pending job
+	// of its kind is that job
+	Key      string          `json:"key,omitempty"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	Priority int             `json:"priority"`
+	State    State           `json:"state"`
+	Attempts int             `json:"attempts"`
+	// MaxAttempts is how many times the job runs before it is dead
+	MaxAttempts int       `json:"max_attempts"`
+	CreatedAt   time.Time `json:"created_at"`
+	// RunAt is when the next attempt of the job is due
+	RunAt     time.Time `json:"run_at"`
+	LastError string    `json:"last_error,omitempty"`
+	// DeadAt is when the job died
+	DeadAt time.Time `json:"dead_at,omitzero"`
+}
+
+// Handler runs jobs of a kind with their payload; ctx ends when the queue
+// stops. Handlers are retried, so they must be safe to run again.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+// EnqueueOptions tune a job
+type EnqueueOptions struct {
+	// Priority orders the jobs that are due; higher runs first
+	Priority int
+	// MaxAttempts overrides the attempts of the queue
+	MaxAttempts int
+	// Delay postpones the first attempt
+	Delay time.Duration
+	// Key deduplicates the job against the pending jobs of its kind
+	Key string
+}
+
+// Options configure a queue
+type Options struct {
+	// Path persists the jobs (in memory if empty)
+	Path        string
+	Workers     int
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	DeadLetters int
+}
+
+// Stats summarizes a queue
+type Stats struct {
+	Pending int `json:"pending"`
+	Running int `json:"running"`
+	Dead    int `json:"dead"`
+	// Completed and Retried count the jobs that succeeded and the attempts
+	// that failed since the queue was created
+	Completed uint64 `json:"completed"`
+	Retried   uint64 `json:"retried"`
+	// Kinds counts the jobs that are not dead by kind
+	Kinds map[string]int `json:"kinds,omitempty"`
+}
+
+// state is what a queue persists
+type state struct {
+	Jobs []*Job `json:"jobs"`
+}
+
+// Queue runs jobs with the handlers of their kinds
+type Queue struct {
+	opts   Options
+	now    func() time.Time
+	jitter func(d time.Duration) time.Duration
+	ctx    context.Context
+	cancel context.CancelFunc
+	runs   sync.WaitGroup
+
+	mu        sync.Mutex
+	jobs      map[string]*Job
+	handlers  map[string]Handler
+	running   int
+	completed uint64
+	retried   uint64
+	dirty     bool
+	// wake redispatches after jobs were added or finished
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates an empty queue, which runs no jobs until it is started
+func New(opts Options) *Queue {
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = DefaultBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+	if opts.DeadLetters <= 0 {
+		opts.DeadLetters = DefaultDeadLetters
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Queue{
+		opts:     opts,
+		now:      time.Now,
+		jitter:   equalJitter,
+		ctx:      ctx,
+		cancel:   cancel,
+		jobs:     make(map[string]*Job),
+		handlers: make(map[string]Handler),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// equalJitter spreads retries of jobs that failed together over the
+// second half of their backoff
+func equalJitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + mathrand.N(d-d/2)
+}
+
+// Handle sets the handler of a kind of jobs. Jobs of kinds without one
+// stay pending, so jobs persisted by a subsystem that is disabled now
+// are kept for when it is enabled again.
+func (q *Queue) Handle(kind string, h Handler) error {
+	if !validKind.MatchString(kind) || h == nil {
+		return fmt.Errorf("%w: malformed kind %q or no handler", ErrInvalid, kind)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.handlers[kind]; ok {
+		return fmt.Errorf("%w: %s already has a handler", ErrInvalid, kind)
+	}
+	q.handlers[kind] = h
+	q.wakeLocked()
+	return nil
+}
+
+// Enqueue adds a job, with its payload encoded as JSON
+func (q *Queue) Enqueue(kind string, payload any, opts EnqueueOptions) (Job, error) {
+	if !validKind.MatchString(kind) {
+		return Job{}, fmt.Errorf("%w: malformed kind %q", ErrInvalid, kind)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Job{}, fmt.Errorf("%w: %s payload: %w", ErrInvalid, kind, err)
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = q.opts.MaxAttempts
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if opts.Key != "" {
+		for _, j := range q.jobs {
+			if j.Kind == kind && j.Key == opts.Key && j.State == Pending {
+				return *j, nil
+			}
+		}
+	}
+	now := q.now()
+	j := &Job{
+		ID: newID(), Kind: kind, Key: opts.Key, Payload: data, Priority: opts.Priority, State: Pending,
+		MaxAttempts: opts.MaxAttempts, CreatedAt: now, RunAt: now.Add(max(opts.Delay, 0)),
+	}
+	q.jobs[j.ID] = j
+	q.dirty = true
+	q.wakeLocked()
+	return *j, nil
+}
+
+// Get returns a job
+func (q *Queue) Get(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return *j, nil
+}
+
+// List returns the jobs in a state, all of them if it is empty, optionally
+// of one kind. Dead jobs come newest first, the others in the order they
+// run.
+func (q *Queue) List(st State, kind string) []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var jobs []Job
+	for _, j := range q.jobs {
+		if (st == "" || j.State == st) && (kind == "" || j.Kind == kind) {
+			jobs = append(jobs, *j)
+		}
+	}
+	slices.SortFunc(jobs, func(a, b Job) int {
+		if a.State != b.State {
+			return cmp.Compare(stateOrder(a.State), stateOrder(b.State))
+		}
+		if a.State == Dead {
+			return cmp.Or(b.DeadAt.Compare(a.DeadAt), cmp.Compare(a.ID, b.ID))
+		}
+		return runOrder(&a, &b)
+	})
+	return jobs
+}
+
+func stateOrder(st State) int {
+	return slices.Index([]State{Running, Pending, Dead}, st)
+}
+
+// runOrder orders jobs by priority, then by when they are due
+func runOrder(a, b *Job) int {
+	return cmp.Or(
+		cmp.Compare(b.Priority, a.Priority),
+		a.RunAt.Compare(b.RunAt),
+		a.CreatedAt.Compare(b.CreatedAt),
+		cmp.Compare(a.ID, b.ID),
+	)
+}
+
+// Stats summarizes the jobs
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	st := Stats{Completed: q.completed, Retried: q.retried, Kinds: make(map[string]int)}
+	for _, j := range q.jobs {
+		switch j.State {
+		case Pending:
+			st.Pending++
+		case Running:
+			st.Running++
+		case Dead:
+			st.Dead++
+			continue
+		}
+		st.Kinds[j.Kind]++
+	}
+	return st
+}
+
+// Requeue gives a dead job its attempts back and runs it again
+func (q *Queue) Requeue(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if j.State != Dead {
+		return Job{}, fmt.Errorf("%w: %s is %s, only dead jobs are requeued", ErrInvalid, id, j.State)
+	}
+	j.State, j.Attempts, j.RunAt, j.DeadAt = Pending, 0, q.now(), time.Time{}
+	q.dirty = true
+	q.wakeLocked()
+	return *j, nil
+}
+
+// RequeueDead requeues the dead jobs, of one kind if it is not empty, and
+// returns how many it requeued
+func (q *Queue) RequeueDead(kind string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, j := range q.jobs {
+		if j.State == Dead && (kind == "" || j.Kind == kind) {
+			j.State, j.Attempts, j.RunAt, j.DeadAt = Pending, 0, q.now(), time.Time{}
+			n++
+		}
+	}
+	if n > 0 {
+		q.dirty = true
+		q.wakeLocked()
+	}
+	return n
+}
+
+// Delete drops a pending or dead job; running jobs finish first
+func (q *Queue) Delete(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if j.State == Running {
+		return fmt.Errorf("%w: %s is running", ErrInvalid, id)
+	}
+	delete(q.jobs, id)
+	q.dirty = true
+	return nil
+}
+
+func (q *Queue) wakeLocked() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the jobs until Stop is called, persisting them as they change
+func (q *Queue) Start() {
+	q.mu.Lock()
+	if q.stop != nil {
+		q.mu.Unlock()
+		return
+	}
+	q.stop = make(chan struct{})
+	q.done = make(chan struct{})
+	stop, done := q.stop, q.done
+	q.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		for {
+			wait := q.dispatch(q.now())
+			if err := q.Flush(); err != nil {
+				slog.Warn("failed to persist the job queue", "error", err)
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-q.wake:
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// Stop stops dispatching, cancels the jobs that run, which run again
+// after a restart, and persists the queue
+func (q *Queue) Stop() error {
+	q.mu.Lock()
+	stop, done := q.stop, q.done
+	q.stop, q.done = nil, nil
+	q.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	q.cancel()
+	q.runs.Wait()
+	return q.Flush()
+}
+
+// dispatch starts the jobs that are due while workers are free and returns
+// how long to wait for the next one
+func (q *Queue) dispatch(now time.Time) time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var due []*Job
+	var earliest time.Time
+	for _, j := range q.jobs {
+		if j.State != Pending || q.handlers[j.Kind] == nil {
+			continue
+		}
+		if j.RunAt.After(now) {
+			if earliest.IsZero() || j.RunAt.Before(earliest) {
+				earliest = j.RunAt
+			}
+			continue
+		}
+		due = append(due, j)
+	}
+	slices.SortFunc(due, runOrder)
+	for _, j := range due {
+		if q.running >= q.opts.Workers || q.ctx.Err() != nil {
+			// The rest run when a worker frees up, which wakes the loop
+			return time.Hour
+		}
+		q.startLocked(j)
+	}
+	if earliest.IsZero() {
+		return time.Hour
+	}
+	return max(earliest.Sub(now), 0)
+}
+
+// startLocked runs a job on a worker and settles it with its outcome
+func (q *Queue) startLocked(j *Job) {
+	h := q.handlers[j.Kind]
+	j.State = Running
+	j.Attempts++
+	q.running++
+	q.dirty = true
+	payload := j.Payload
+	q.runs.Add(1)
+	go func() {
+		defer q.runs.Done()
+		err := runHandler(q.ctx, h, payload)
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.running--
+		q.dirty = true
+		q.wakeLocked()
+		if _, ok := q.jobs[j.ID]; !ok {
+			return
+		}
+		now := q.now()
+		switch {
+		case err == nil:
+			delete(q.jobs, j.ID)
+			q.completed++
+		case q.ctx.Err() != nil:
+			// Stopping interrupted the job, which does not count against it
+			j.State, j.Attempts = Pending, j.Attempts-1
+		case errors.Is(err, ErrPermanent) || j.Attempts >= j.MaxAttempts:
+			j.State, j.LastError, j.DeadAt = Dead, err.Error(), now
+			q.retried++
+			slog.Warn("job failed for good", "job", j.ID, "kind", j.Kind, "attempts", j.Attempts, "error", err)
+			q.trimDeadLocked()
+		default:
+			j.State, j.LastError = Pending, err.Error()
+			j.RunAt = now.Add(q.backoff(j.Attempts))
+			q.retried++
+			slog.Debug("job failed, retrying", "job", j.ID, "kind", j.Kind, "attempt", j.Attempts, "error", err)
+		}
+	}()
+}
+
+// backoff returns how long a job waits after failing its nth attempt
+func (q *Queue) backoff(attempt int) time.Duration {
+	d := q.opts.Backoff
+	for i := 1; i < attempt && d < q.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	return q.jitter(min(d, q.opts.MaxBackoff))
+}
+
+// trimDeadLocked drops the oldest dead jobs beyond the dead letters kept
+func (q *Queue) trimDeadLocked() {
+	var dead []*Job
+	for _, j := range q.jobs {
+		if j.State == Dead {
+			dead = append(dead, j)
+		}
+	}
+	if len(dead) <= q.opts.DeadLetters {
+		return
+	}
+	slices.SortFunc(dead, func(a, b *Job) int { return a.DeadAt.Compare(b.DeadAt) })
+	for _, j := range dead[:len(dead)-q.opts.DeadLetters] {
+		delete(q.jobs, j.ID)
+	}
+}
+
+// runHandler runs a job, failing it when the handler panics
+func runHandler(ctx context.Context, h Handler, payload json.RawMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, payload)
+}
+
+// Flush persists the jobs if they changed since the last flush
+func (q *Queue) Flush() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.dirty || q.opts.Path == "" {
+		return nil
+	}
+	st := state{Jobs: make([]*Job, 0, len(q.jobs))}
+	for _, j := range q.jobs {
+		st.Jobs = append(st.Jobs, j)
+	}
+	slices.SortFunc(st.Jobs, func(a, b *Job) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(q.opts.Path), 0700); err != nil {
+		return err
+	}
+	tmp := q.opts.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, q.opts.Path); err != nil {
+		return err
+	}
+	q.dirty = false
+	return nil
+}
+
+// Load reads the persisted jobs. Jobs that ran when they were persisted
+// run again.
+func (q *Queue) Load() error {
+	if q.opts.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(q.opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("queue: corrupt state %s: %w", q.opts.Path, err)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range st.Jobs {
+		if j == nil || j.ID == "" {
+			continue
+		}
+		if _, ok := q.jobs[j.ID]; ok {
+			continue
+		}
+		if j.State == Running {
+			j.State = Pending
+		}
+		q.jobs[j.ID] = j
+	}
+	q.wakeLocked()
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,256 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clock is a settable time for driving the queue by hand
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+func newTestQueue(opts Options) (*Queue, *clock) {
+	c := &clock{now: time.Date(2026, 3, 14, 10, 7, 30, 0, time.UTC)}
+	q := New(opts)
+	q.now = c.Now
+	q.jitter = func(d time.Duration) time.Duration { return d }
+	return q, c
+}
+
+// settle waits until no job runs
+func settle(t *testing.T, q *Queue) {
+	t.Helper()
+	require.Eventually(t, func() bool { return q.Stats().Running == 0 }, 5*time.Second, time.Millisecond)
+}
+
+type webhook struct {
+	URL string `json:"url"`
+}
+
+func TestQueueRunsByPriority(t *testing.T) {
+	q, c := newTestQueue(Options{Workers: 1})
+	var mu sync.Mutex
+	var order []string
+	require.NoError(t, q.Handle("webhook", func(ctx context.Context, payload json.RawMessage) error {
+		var w webhook
+		if err := json.Unmarshal(payload, &w); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, w.URL)
+		return nil
+	}))
+	assert.ErrorIs(t, q.Handle("webhook", func(context.Context, json.RawMessage) error { return nil }), ErrInvalid)
+
+	for i, priority := range []int{0, 10, 0, 5} {
+		_, err := q.Enqueue("webhook", webhook{URL: fmt.Sprint(i)}, EnqueueOptions{Priority: priority})
+		require.NoError(t, err)
+		c.Advance(time.Second)
+	}
+	delayed, err := q.Enqueue("webhook", webhook{URL: "later"}, EnqueueOptions{Priority: 100, Delay: time.Minute})
+	require.NoError(t, err)
+	_, err = q.Enqueue("Bad Kind", nil, EnqueueOptions{})
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	for range 4 {
+		q.dispatch(c.Now())
+		settle(t, q)
+	}
+	mu.Lock()
+	assert.Equal(t, []string{"1", "3", "0", "2"}, order)
+	mu.Unlock()
+
+	// The delayed job is what the queue waits for next
+	assert.Equal(t, time.Minute, q.dispatch(c.Now()))
+	q.dispatch(c.Advance(time.Minute))
+	settle(t, q)
+	_, err = q.Get(delayed.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, uint64(5), q.Stats().Completed)
+}
+
+func TestQueueRetriesWithBackoff(t *testing.T) {
+	q, c := newTestQueue(Options{MaxAttempts: 4, Backoff: time.Second, MaxBackoff: 3 * time.Second})
+	require.NoError(t, q.Handle("replicate", func(ctx context.Context, payload json.RawMessage) error {
+		return errors.New("peer unreachable")
+	}))
+	job, err := q.Enqueue("replicate", map[string]string{"key": "a"}, EnqueueOptions{})
+	require.NoError(t, err)
+
+	// Waits double up to the cap: 1s, 2s, 3s
+	for _, wait := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		q.dispatch(c.Now())
+		settle(t, q)
+		assert.Equal(t, wait, q.dispatch(c.Now()))
+		got, err := q.Get(job.ID)
+		require.NoError(t, err)
+		assert.Equal(t, Pending, got.State)
+		assert.Equal(t, "peer unreachable", got.LastError)
+		c.Advance(wait)
+	}
+	q.dispatch(c.Now())
+	settle(t, q)
+	got, err := q.Get(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, Dead, got.State)
+	assert.Equal(t, 4, got.Attempts)
+	assert.Equal(t, c.Now(), got.DeadAt)
+	assert.Equal(t, time.Hour, q.dispatch(c.Now()))
+	assert.Equal(t, Stats{Dead: 1, Retried: 4, Kinds: map[string]int{}}, q.Stats())
+}
+
+func TestQueueDeadLetters(t *testing.T) {
+	q, c := newTestQueue(Options{DeadLetters: 2})
+	var mu sync.Mutex
+	fail := true
+	require.NoError(t, q.Handle("thumbnail", func(ctx context.Context, payload json.RawMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			return fmt.Errorf("%w: not an image", ErrPermanent)
+		}
+		return nil
+	}))
+	var ids []string
+	for i := range 3 {
+		job, err := q.Enqueue("thumbnail", i, EnqueueOptions{})
+		require.NoError(t, err)
+		ids = append(ids, job.ID)
+		q.dispatch(c.Now())
+		settle(t, q)
+		c.Advance(time.Second)
+	}
+
+	// Permanent failures die at once, and only the newest dead letters are
+	// kept
+	dead := q.List(Dead, "")
+	require.Len(t, dead, 2)
+	assert.Equal(t, ids[2], dead[0].ID)
+	assert.Equal(t, ids[1], dead[1].ID)
+	assert.Equal(t, 1, dead[0].Attempts)
+	assert.Contains(t, dead[0].LastError, "not an image")
+
+	_, err := q.Requeue("missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	pending, err := q.Enqueue("thumbnail", 3, EnqueueOptions{Delay: time.Hour})
+	require.NoError(t, err)
+	_, err = q.Requeue(pending.ID)
+	assert.ErrorIs(t, err, ErrInvalid)
+	require.NoError(t, q.Delete(pending.ID))
+
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	requeued, err := q.Requeue(ids[2])
+	require.NoError(t, err)
+	assert.Equal(t, Pending, requeued.State)
+	assert.Zero(t, requeued.Attempts)
+	assert.Equal(t, 1, q.RequeueDead("thumbnail"))
+	q.dispatch(c.Now())
+	settle(t, q)
+	assert.Empty(t, q.List("", ""))
+}
+
+func TestQueueDeduplicatesByKey(t *testing.T) {
+	q, _ := newTestQueue(Options{})
+	a, err := q.Enqueue("replicate", "a", EnqueueOptions{Key: "k@node-b"})
+	require.NoError(t, err)
+	b, err := q.Enqueue("replicate", "a", EnqueueOptions{Key: "k@node-b"})
+	require.NoError(t, err)
+	assert.Equal(t, a.ID, b.ID)
+	other, err := q.Enqueue("replicate", "a", EnqueueOptions{Key: "k@node-c"})
+	require.NoError(t, err)
+	assert.NotEqual(t, a.ID, other.ID)
+	assert.Equal(t, 2, q.Stats().Kinds["replicate"])
+}
+
+func TestQueuePersistsJobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	q, c := newTestQueue(Options{Path: path, MaxAttempts: 1})
+	started := make(chan struct{})
+	require.NoError(t, q.Handle("reencrypt", func(ctx context.Context, payload json.RawMessage) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	require.NoError(t, q.Handle("webhook", func(ctx context.Context, payload json.RawMessage) error {
+		return errors.New("410 gone")
+	}))
+	running, err := q.Enqueue("reencrypt", map[string]string{"path": "a"}, EnqueueOptions{})
+	require.NoError(t, err)
+	dead, err := q.Enqueue("webhook", webhook{URL: "https://example.com"}, EnqueueOptions{})
+	require.NoError(t, err)
+	// Jobs without a handler wait for one
+	waiting, err := q.Enqueue("thumbnail", "b", EnqueueOptions{})
+	require.NoError(t, err)
+	q.dispatch(c.Now())
+	<-started
+	require.Eventually(t, func() bool { return q.Stats().Dead == 1 }, 5*time.Second, time.Millisecond)
+
+	// Stopping interrupts the running job without counting the attempt
+	require.NoError(t, q.Stop())
+
+	loaded, _ := newTestQueue(Options{Path: path})
+	require.NoError(t, loaded.Load())
+	got, err := loaded.Get(running.ID)
+	require.NoError(t, err)
+	assert.Equal(t, Pending, got.State)
+	assert.Zero(t, got.Attempts)
+	got, err = loaded.Get(dead.ID)
+	require.NoError(t, err)
+	assert.Equal(t, Dead, got.State)
+	assert.Equal(t, "410 gone", got.LastError)
+	got, err = loaded.Get(waiting.ID)
+	require.NoError(t, err)
+	assert.JSONEq(t, `"b"`, string(got.Payload))
+}
+
+func TestQueueStartRunsJobs(t *testing.T) {
+	q := New(Options{})
+	done := make(chan string, 2)
+	require.NoError(t, q.Handle("webhook", func(ctx context.Context, payload json.RawMessage) error {
+		done <- string(payload)
+		return nil
+	}))
+	require.NoError(t, q.Handle("panics", func(ctx context.Context, payload json.RawMessage) error {
+		panic("boom")
+	}))
+	q.Start()
+	defer q.Stop()
+	_, err := q.Enqueue("webhook", "hello", EnqueueOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, `"hello"`, <-done)
+	job, err := q.Enqueue("panics", nil, EnqueueOptions{MaxAttempts: 1})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		got, err := q.Get(job.ID)
+		return err == nil && got.State == Dead
+	}, 5*time.Second, time.Millisecond)
+	got, _ := q.Get(job.ID)
+	assert.Equal(t, "panic: boom", got.LastError)
+}
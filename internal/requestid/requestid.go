@@ -0,0 +1,116 @@
+// Package requestid carries the ID of a request through a node: the HTTP
+// middleware takes it from the X-Request-ID header, or makes one up, and
+// everything handling the request finds it in the context, so logs, audit
+// entries and error responses of one operation share it.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+)
+
+// Header is the header carrying request IDs, both ways
+const Header = "X-Request-ID"
+
+// maxLength bounds request IDs taken from clients
+const maxLength = 128
+
+type contextKey struct{}
+
+// New generates a random request ID
+func New() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Valid accepts printable ASCII IDs of a sane length, so client IDs cannot
+// smuggle line breaks into logs or headers
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext returns ctx carrying id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID ctx carries, or ""
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Middleware gives every request an ID, the client's when it sent a valid
+// one, and returns it in the response header
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !Valid(id) {
+			id = FromContext(r.Context())
+		}
+		if id == "" {
+			id = New()
+		}
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
+
+// Propagate sets the request ID of ctx on an outgoing request, so the next
+// node logs the same operation under the same ID
+func Propagate(ctx context.Context, r *http.Request) {
+	if id := FromContext(ctx); id != "" && r.Header.Get(Header) == "" {
+		r.Header.Set(Header, id)
+	}
+}
+
+// LogHandler adds the request ID of the context to every record logged
+// with one, as request_id, unless the record names one already
+type LogHandler struct {
+	slog.Handler
+}
+
+// NewLogHandler wraps h
+func NewLogHandler(h slog.Handler) *LogHandler {
+	return &LogHandler{Handler: h}
+}
+
+// Handle adds the request ID and passes the record on
+func (h *LogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := FromContext(ctx); id != "" && !hasRequestID(record) {
+		record = record.Clone()
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs keeps the wrapper around the derived handler
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &LogHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup keeps the wrapper around the derived handler
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+	return &LogHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+func hasRequestID(record slog.Record) bool {
+	found := false
+	record.Attrs(func(a slog.Attr) bool {
+		found = a.Key == "request_id"
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,71 @@
+package requestid
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValid(t *testing.T) {
+	assert.True(t, Valid("trace-1"))
+	assert.True(t, Valid(New()))
+	assert.False(t, Valid(""))
+	assert.False(t, Valid("two words"))
+	assert.False(t, Valid("line\nbreak"))
+	assert.False(t, Valid(string(make([]byte, maxLength+1))))
+}
+
+func TestMiddleware(t *testing.T) {
+	var seen string
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = FromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(Header, "trace-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, "trace-1", seen)
+	assert.Equal(t, "trace-1", w.Header().Get(Header))
+
+	// Unusable IDs are replaced
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(Header, "bad id")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.NotEqual(t, "bad id", seen)
+	assert.True(t, Valid(seen))
+	assert.Equal(t, seen, w.Header().Get(Header))
+}
+
+func TestPropagate(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	Propagate(context.Background(), req)
+	assert.Empty(t, req.Header.Get(Header))
+
+	Propagate(NewContext(context.Background(), "trace-2"), req)
+	assert.Equal(t, "trace-2", req.Header.Get(Header))
+}
+
+func TestLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewLogHandler(slog.NewTextHandler(&buf, nil))).With("component", "test")
+	ctx := NewContext(context.Background(), "trace-3")
+
+	logger.InfoContext(ctx, "with request")
+	assert.Contains(t, buf.String(), "request_id=trace-3")
+
+	buf.Reset()
+	logger.InfoContext(ctx, "own ID", "request_id", "trace-4")
+	assert.Contains(t, buf.String(), "request_id=trace-4")
+	assert.NotContains(t, buf.String(), "trace-3")
+
+	buf.Reset()
+	logger.Info("no request")
+	assert.NotContains(t, buf.String(), "request_id")
+}
@@ -0,0 +1,332 @@
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/audit"
+)
+
+var (
+	// ErrLocked is returned when a delete or overwrite hits a locked key
+	ErrLocked = errors.New("retention: object is locked")
+	// ErrShortenRetention is returned when a retention period would be
+	// reduced; write-once retention can only be extended
+	ErrShortenRetention = errors.New("retention: retention can only be extended")
+	ErrInvalidRetention = errors.New("retention: retain-until must be in the future")
+)
+
+// Operation is a change a lock guards against
+type Operation string
+
+const (
+	OpDelete    Operation = "delete"
+	OpOverwrite Operation = "overwrite"
+)
+
+// Lock makes a key immutable until RetainUntil or while LegalHold is set
+type Lock struct {
+	Key         string    `json:"key"`
+	RetainUntil time.Time `json:"retain_until,omitempty"`
+	LegalHold   bool      `json:"legal_hold"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	UpdatedBy   string    `json:"updated_by,omitempty"`
+}
+
+// Locked reports whether the lock blocks changes at the given time
+func (l *Lock) Locked(now time.Time) bool {
+	return l.LegalHold || now.Before(l.RetainUntil)
+}
+
+// LockedError describes why a change was rejected
+type LockedError struct {
+	Key         string
+	Op          Operation
+	RetainUntil time.Time
+	LegalHold   bool
+}
+
+func (e *LockedError) Error() string {
+	var reasons []string
+	if e.LegalHold {
+		reasons = append(reasons, "under legal hold")
+	}
+	if !e.RetainUntil.IsZero() && time.Now().Before(e.RetainUntil) {
+		reasons = append(reasons, "retained until "+e.RetainUntil.UTC().Format(time.RFC3339))
+	}
+	return fmt.Sprintf("retention: cannot %s %s: %s", e.Op, e.Key, strings.Join(reasons, " and "))
+}
+
+func (e *LockedError) Is(target error) bool { return target == ErrLocked }
+
+// ManagerOpts configures a Manager
+type ManagerOpts struct {
+	// Path persists locks; empty keeps them in memory
+	Path string
+	// Audit receives lock changes and rejected attempts; defaults to the
+	// global audit logger
+	Audit *audit.AuditLogger
+}
+
+// Manager tracks retention locks and legal holds per key
+type Manager struct {
+	opts ManagerOpts
+
+	mu    sync.Mutex
+	locks map[string]*Lock
+}
+
+// NewManager creates a lock manager, loading persisted locks from opts.Path
+func NewManager(opts ManagerOpts) (*Manager, error) {
+	m := &Manager{opts: opts, locks: make(map[string]*Lock)}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Get returns the lock of a key, if any
+func (m *Manager) Get(key string) (Lock, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lock, ok := m.locks[key]
+	if !ok {
+		return Lock{}, false
+	}
+	return *lock, true
+}
+
+// List returns the locks still in force, ordered by key
+func (m *Manager) List() []Lock {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	locks := make([]Lock, 0, len(m.locks))
+	for _, lock := range m.locks {
+		if lock.Locked(now) {
+			locks = append(locks, *lock)
+		}
+	}
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Key < locks[j].Key })
+	return locks
+}
+
+// SetRetention keeps a key immutable until the given time. Like WORM
+// media, an existing retention period can be extended but never shortened.
+func (m *Manager) SetRetention(ctx context.Context, key string, until time.Time, actor string) (Lock, error) {
+	if key == "" {
+		return Lock{}, errors.New("retention: key is required")
+	}
+	now := time.Now()
+	if !until.After(now) {
+		return Lock{}, ErrInvalidRetention
+	}
+
+	m.mu.Lock()
+	lock, ok := m.locks[key]
+	if !ok {
+		lock = &Lock{Key: key}
+	}
+	if until.Before(lock.RetainUntil) {
+		m.mu.Unlock()
+		return Lock{}, fmt.Errorf("%w: %s is retained until %s", ErrShortenRetention, key, lock.RetainUntil.UTC().Format(time.RFC3339))
+	}
+	updated := *lock
+	updated.RetainUntil = until.UTC()
+	updated.UpdatedAt = now
+	updated.UpdatedBy = actor
+	if err := m.storeLocked(&updated); err != nil {
+		m.mu.Unlock()
+		return Lock{}, err
+	}
+	m.mu.Unlock()
+
+	m.audit(ctx, updated, "retention_set", "success", actor, map[string]interface{}{
+		"retain_until": updated.RetainUntil.Format(time.RFC3339),
+	})
+	return updated, nil
+}
+
+// SetLegalHold places or releases a legal hold, which blocks changes
+// regardless of retention until it is released
+func (m *Manager) SetLegalHold(ctx context.Context, key string, hold bool, actor string) (Lock, error) {
+	if key == "" {
+		return Lock{}, errors.New("retention: key is required")
+	}
+
+	m.mu.Lock()
+	lock, ok := m.locks[key]
+	if !ok {
+		lock = &Lock{Key: key}
+	}
+	updated := *lock
+	updated.LegalHold = hold
+	updated.UpdatedAt = time.Now()
+	updated.UpdatedBy = actor
+	if err := m.storeLocked(&updated); err != nil {
+		m.mu.Unlock()
+		return Lock{}, err
+	}
+	m.mu.Unlock()
+
+	action := "legal_hold_placed"
+	if !hold {
+		action = "legal_hold_released"
+	}
+	m.audit(ctx, updated, action, "success", actor, nil)
+	return updated, nil
+}
+
+// Check returns a *LockedError when op on key is not allowed and records
+// the rejected attempt in the audit log
+func (m *Manager) Check(ctx context.Context, key string, op Operation, actor string) error {
+	m.mu.Lock()
+	lock, ok := m.locks[key]
+	var snapshot Lock
+	if ok {
+		snapshot = *lock
+	}
+	m.mu.Unlock()
+
+	if !ok || !snapshot.Locked(time.Now()) {
+		return nil
+	}
+
+	err := &LockedError{Key: key, Op: op, RetainUntil: snapshot.RetainUntil, LegalHold: snapshot.LegalHold}
+	m.audit(ctx, snapshot, string(op), "denied", actor, map[string]interface{}{"reason": err.Error()})
+	return err
+}
+
+// storeLocked saves a lock, dropping it once it no longer restricts anything
+func (m *Manager) storeLocked(lock *Lock) error {
+	previous, had := m.locks[lock.Key]
+	if lock.Locked(time.Now()) {
+		m.locks[lock.Key] = lock
+	} else {
+		delete(m.locks, lock.Key)
+	}
+	if err := m.saveLocked(); err != nil {
+		if had {
+			m.locks[lock.Key] = previous
+		} else {
+			delete(m.locks, lock.Key)
+		}
+		return fmt.Errorf("retention: failed to persist locks: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) audit(ctx context.Context, lock Lock, action, result, actor string, details map[string]interface{}) {
+	logger := m.opts.Audit
+	if logger == nil {
+		logger = audit.GlobalAuditLogger
+	}
+	if logger == nil {
+		slog.Info("retention lock event", "key", lock.Key, "action", action, "result", result, "actor", actor)
+		return
+	}
+
+	if details == nil {
+		details = make(map[string]interface{})
+	}
+	details["legal_hold"] = lock.LegalHold
+	if !lock.RetainUntil.IsZero() {
+		details["retain_until"] = lock.RetainUntil.Format(time.RFC3339)
+	}
+
+	level := audit.AuditLevelInfo
+	if result != "success" {
+		level = audit.AuditLevelWarning
+	}
+	event := &audit.AuditEvent{
+		Type:     audit.AuditEventTypeCompliance,
+		Level:    level,
+		UserID:   actor,
+		Resource: lock.Key,
+		Action:   action,
+		Result:   result,
+		Message:  fmt.Sprintf("Retention %s on %s: %s", action, lock.Key, result),
+		Details:  details,
+		Source:   "retention",
+		Category: "object_lock",
+		Tags:     []string{"retention", action, result},
+	}
+	if err := logger.LogEvent(ctx, event); err != nil {
+		slog.Warn("retention: failed to write audit event", "error", err)
+	}
+}
+
+func (m *Manager) load() error {
+	if m.opts.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var locks []*Lock
+	if err := json.Unmarshal(data, &locks); err != nil {
+		return fmt.Errorf("retention: corrupt lock store %s: %w", m.opts.Path, err)
+	}
+	for _, lock := range locks {
+		m.locks[lock.Key] = lock
+	}
+	return nil
+}
+
+func (m *Manager) saveLocked() error {
+	if m.opts.Path == "" {
+		return nil
+	}
+	locks := make([]*Lock, 0, len(m.locks))
+	for _, lock := range m.locks {
+		locks = append(locks, lock)
+	}
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Key < locks[j].Key })
+	data, err := json.MarshalIndent(locks, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.opts.Path), 0700); err != nil {
+		return err
+	}
+	tmp := m.opts.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.opts.Path)
+}
+
+// ParseRetention parses a retention period such as "720h" or "30d", or an
+// RFC 3339 retain-until timestamp, relative to now
+func ParseRetention(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return time.Time{}, fmt.Errorf("retention: invalid period %q", s)
+		}
+		return now.AddDate(0, 0, n), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return time.Time{}, fmt.Errorf("retention: invalid period %q", s)
+	}
+	return now.Add(d), nil
+}
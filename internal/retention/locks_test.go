@@ -0,0 +1,136 @@
+package retention
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestManager(t *testing.T, path string) (*Manager, *audit.AuditLogger) {
+	t.Helper()
+	logger, err := audit.NewAuditLogger(filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = logger.Close() })
+
+	m, err := NewManager(ManagerOpts{Path: path, Audit: logger})
+	require.NoError(t, err)
+	return m, logger
+}
+
+func TestRetentionBlocksChangesUntilExpiry(t *testing.T) {
+	m, logger := newTestManager(t, "")
+	ctx := context.Background()
+
+	require.NoError(t, m.Check(ctx, "report.pdf", OpDelete, "alice"))
+
+	until := time.Now().Add(time.Hour)
+	lock, err := m.SetRetention(ctx, "report.pdf", until, "compliance")
+	require.NoError(t, err)
+	assert.True(t, lock.Locked(time.Now()))
+	assert.False(t, lock.Locked(until.Add(time.Second)))
+
+	err = m.Check(ctx, "report.pdf", OpDelete, "alice")
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.Contains(t, err.Error(), "retained until")
+	assert.ErrorIs(t, m.Check(ctx, "report.pdf", OpOverwrite, "alice"), ErrLocked)
+	assert.NoError(t, m.Check(ctx, "other.pdf", OpDelete, "alice"))
+
+	// Retention can be extended but not shortened
+	_, err = m.SetRetention(ctx, "report.pdf", until.Add(-time.Minute), "alice")
+	assert.ErrorIs(t, err, ErrShortenRetention)
+	_, err = m.SetRetention(ctx, "report.pdf", until.Add(time.Hour), "compliance")
+	assert.NoError(t, err)
+	_, err = m.SetRetention(ctx, "report.pdf", time.Now().Add(-time.Hour), "compliance")
+	assert.ErrorIs(t, err, ErrInvalidRetention)
+
+	denied := logger.GetEvents(&audit.AuditFilter{Resource: "report.pdf", Result: "denied"})
+	require.Len(t, denied, 2)
+	assert.Equal(t, "delete", denied[0].Action)
+	assert.Equal(t, "alice", denied[0].UserID)
+	assert.Equal(t, audit.AuditEventTypeCompliance, denied[0].Type)
+	assert.Len(t, logger.GetEvents(&audit.AuditFilter{Action: "retention_set"}), 2)
+}
+
+func TestLegalHold(t *testing.T) {
+	m, logger := newTestManager(t, "")
+	ctx := context.Background()
+
+	_, err := m.SetLegalHold(ctx, "case/evidence.zip", true, "legal")
+	require.NoError(t, err)
+	err = m.Check(ctx, "case/evidence.zip", OpDelete, "bob")
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.Contains(t, err.Error(), "legal hold")
+	assert.Len(t, m.List(), 1)
+
+	// Releasing the only restriction forgets the lock
+	_, err = m.SetLegalHold(ctx, "case/evidence.zip", false, "legal")
+	require.NoError(t, err)
+	assert.NoError(t, m.Check(ctx, "case/evidence.zip", OpDelete, "bob"))
+	_, ok := m.Get("case/evidence.zip")
+	assert.False(t, ok)
+	assert.Empty(t, m.List())
+
+	assert.Len(t, logger.GetEvents(&audit.AuditFilter{Action: "legal_hold_placed"}), 1)
+	assert.Len(t, logger.GetEvents(&audit.AuditFilter{Action: "legal_hold_released"}), 1)
+}
+
+func TestLegalHoldOutlastsRetention(t *testing.T) {
+	m, _ := newTestManager(t, "")
+	ctx := context.Background()
+
+	_, err := m.SetRetention(ctx, "a", time.Now().Add(50*time.Millisecond), "ops")
+	require.NoError(t, err)
+	_, err = m.SetLegalHold(ctx, "a", true, "legal")
+	require.NoError(t, err)
+
+	time.Sleep(60 * time.Millisecond)
+	assert.ErrorIs(t, m.Check(ctx, "a", OpDelete, "ops"), ErrLocked)
+
+	_, err = m.SetLegalHold(ctx, "a", false, "legal")
+	require.NoError(t, err)
+	assert.NoError(t, m.Check(ctx, "a", OpDelete, "ops"))
+}
+
+func TestLocksPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locks.json")
+	m, _ := newTestManager(t, path)
+	ctx := context.Background()
+
+	until := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	_, err := m.SetRetention(ctx, "a", until, "ops")
+	require.NoError(t, err)
+	_, err = m.SetLegalHold(ctx, "b", true, "legal")
+	require.NoError(t, err)
+
+	reopened, _ := newTestManager(t, path)
+	lock, ok := reopened.Get("a")
+	require.True(t, ok)
+	assert.True(t, lock.RetainUntil.Equal(until))
+	assert.ErrorIs(t, reopened.Check(ctx, "b", OpOverwrite, "ops"), ErrLocked)
+}
+
+func TestParseRetention(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	until, err := ParseRetention("30d", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, 30), until)
+
+	until, err = ParseRetention("90m", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(90*time.Minute), until)
+
+	until, err = ParseRetention("2027-01-01T00:00:00Z", now)
+	require.NoError(t, err)
+	assert.Equal(t, 2027, until.Year())
+
+	for _, bad := range []string{"", "0d", "-1h", "soon"} {
+		_, err := ParseRetention(bad, now)
+		assert.Error(t, err, bad)
+	}
+}
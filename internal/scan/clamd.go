@@ -0,0 +1,88 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// clamdChunkSize is the size of the chunks streamed to clamd, well below
+// its default StreamMaxLength
+const clamdChunkSize = 64 << 10
+
+// Clamd scans content with a ClamAV daemon over its INSTREAM command
+type Clamd struct {
+	network, addr string
+}
+
+// NewClamd returns a scanner for the clamd at addr: host:port, or
+// unix:/path/to/clamd.sock
+func NewClamd(addr string) *Clamd {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return &Clamd{network: "unix", addr: path}
+	}
+	return &Clamd{network: "tcp", addr: addr}
+}
+
+func (c *Clamd) Name() string { return "clamd" }
+
+// Scan streams the content to clamd and returns the name of the signature
+// it matched
+func (c *Clamd) Scan(ctx context.Context, obj Object) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.addr)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	w := bufio.NewWriter(conn)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return "", err
+	}
+	var size [4]byte
+	for data := obj.Data; len(data) > 0; {
+		n := min(len(data), clamdChunkSize)
+		binary.BigEndian.PutUint32(size[:], uint32(n))
+		if _, err := w.Write(size[:]); err != nil {
+			return "", err
+		}
+		if _, err := w.Write(data[:n]); err != nil {
+			return "", err
+		}
+		data = data[n:]
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	if _, err := w.Write(size[:]); err != nil {
+		return "", err
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", fmt.Errorf("no reply from clamd: %w", err)
+	}
+	return parseClamdReply(reply)
+}
+
+// parseClamdReply reads "stream: OK" or "stream: <signature> FOUND"
+func parseClamdReply(reply string) (string, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd: %s", reply)
+	}
+}
@@ -0,0 +1,117 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// defaultICAPPort is the port of icap:// URLs without one
+const defaultICAPPort = "1344"
+
+// ICAP scans content with an ICAP server (RFC 3507) through RESPMOD: the
+// file is sent as the body of an HTTP response, which the server either
+// lets through unmodified (204) or blocks.
+type ICAP struct {
+	url  *url.URL
+	host string
+}
+
+// NewICAP returns a scanner for the ICAP service at rawURL, e.g.
+// icap://scanner:1344/avscan
+func NewICAP(rawURL string) (*ICAP, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ICAP URL: %w", err)
+	}
+	if u.Scheme != "icap" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid ICAP URL %q: want icap://host[:port]/service", rawURL)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), defaultICAPPort)
+	}
+	return &ICAP{url: u, host: host}, nil
+}
+
+func (i *ICAP) Name() string { return "icap" }
+
+// Scan sends the content to the ICAP service and returns the threat it
+// reported
+func (i *ICAP) Scan(ctx context.Context, obj Object) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", i.host)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	resHdr := "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nContent-Length: " +
+		strconv.Itoa(len(obj.Data)) + "\r\n\r\n"
+	var req bytes.Buffer
+	fmt.Fprintf(&req, "RESPMOD %s ICAP/1.0\r\nHost: %s\r\nAllow: 204\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n",
+		i.url.String(), i.url.Host, len(resHdr))
+	req.WriteString(resHdr)
+	if len(obj.Data) > 0 {
+		fmt.Fprintf(&req, "%x\r\n", len(obj.Data))
+		req.Write(obj.Data)
+		req.WriteString("\r\n")
+	}
+	req.WriteString("0\r\n\r\n")
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return "", err
+	}
+
+	r := textproto.NewReader(bufio.NewReader(conn))
+	status, err := r.ReadLine()
+	if err != nil {
+		return "", fmt.Errorf("no reply from ICAP server: %w", err)
+	}
+	header, err := r.ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return "", fmt.Errorf("invalid ICAP reply: %w", err)
+	}
+	return parseICAPReply(status, header)
+}
+
+// parseICAPReply reads the status and headers of a RESPMOD reply: 204 for
+// clean content, 200 with the content replaced or a threat header for
+// blocked content
+func parseICAPReply(status string, header textproto.MIMEHeader) (string, error) {
+	fields := strings.Fields(status)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "ICAP/") {
+		return "", fmt.Errorf("invalid ICAP status %q", status)
+	}
+	switch fields[1] {
+	case "204":
+		return "", nil
+	case "200":
+	default:
+		return "", fmt.Errorf("ICAP server answered %q", status)
+	}
+
+	if found := header.Get("X-Infection-Found"); found != "" {
+		// Type=0; Resolution=2; Threat=Eicar-Test-Signature;
+		for _, part := range strings.Split(found, ";") {
+			if threat, ok := strings.CutPrefix(strings.TrimSpace(part), "Threat="); ok && threat != "" {
+				return threat, nil
+			}
+		}
+		return found, nil
+	}
+	for _, name := range []string{"X-Virus-Id", "X-Violations-Found", "X-Blocked-Reason"} {
+		if v := header.Get(name); v != "" {
+			return v, nil
+		}
+	}
+	return "blocked by the ICAP server", nil
+}
@@ -0,0 +1,25 @@
+package scan
+
+import (
+	"context"
+
+	"github.com/Skpow1234/Peervault/pkg/plugin"
+)
+
+// Plugin scans content with the scanner of an external plugin
+type Plugin struct {
+	name    string
+	scanner plugin.Scanner
+}
+
+// NewPlugin returns a scanner for the plugin launched as name
+func NewPlugin(name string, scanner plugin.Scanner) *Plugin {
+	return &Plugin{name: name, scanner: scanner}
+}
+
+func (p *Plugin) Name() string { return p.name }
+
+// Scan hands the content to the plugin and returns what it found
+func (p *Plugin) Scan(ctx context.Context, obj Object) (string, error) {
+	return p.scanner.Scan(ctx, obj.Key, obj.Data)
+}
@@ -0,0 +1,335 @@
+// Package scan inspects files as they are stored. A Pipeline sniffs the
+// content type, applies the type and size policies, and passes the content
+// to external scanners such as clamd or an ICAP server. Files a check flags
+// are rejected, quarantined under another namespace or stored with a tag,
+// and the outcome is recorded in the metadata of the file.
+package scan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Action is what happens to a file a check flags
+type Action string
+
+const (
+	// ActionReject refuses to store the file
+	ActionReject Action = "reject"
+	// ActionQuarantine stores the file under the quarantine prefix instead
+	// of its key
+	ActionQuarantine Action = "quarantine"
+	// ActionTag stores the file as usual, tagged TagFlagged
+	ActionTag Action = "tag"
+)
+
+// Status of a scanned file
+const (
+	StatusClean   = "clean"
+	StatusFlagged = "flagged"
+	// StatusUnscanned files were let through although a scanner failed,
+	// because the pipeline fails open
+	StatusUnscanned = "unscanned"
+)
+
+// Metadata keys the outcome of a scan is recorded under
+const (
+	MetaStatus   = "pv-scan-status"
+	MetaType     = "pv-scan-type"
+	MetaFindings = "pv-scan-findings"
+	MetaAction   = "pv-scan-action"
+	MetaAt       = "pv-scan-at"
+	// MetaOriginalKey is the key a quarantined file was stored for
+	MetaOriginalKey = "pv-scan-original-key"
+)
+
+const (
+	// TagFlagged is the tag ActionTag adds to flagged files
+	TagFlagged = "scan-flagged"
+	// DefaultQuarantinePrefix is the namespace of quarantined files
+	DefaultQuarantinePrefix = "quarantine/"
+	// DefaultTimeout bounds each external scanner
+	DefaultTimeout = 30 * time.Second
+)
+
+var (
+	// ErrRejected is returned for files the pipeline refused to store
+	ErrRejected = errors.New("scan: file rejected")
+	// ErrQuarantined is returned for files stored in quarantine
+	ErrQuarantined = errors.New("scan: file quarantined")
+	// ErrScannerFailed is returned when a scanner could not check a file
+	// and the pipeline fails closed
+	ErrScannerFailed = errors.New("scan: scanner failed")
+)
+
+// Object is a file being stored
+type Object struct {
+	Key  string
+	Data []byte
+}
+
+// Scanner is an external check. Scan returns a description of what it
+// found, such as the name of a virus, or "" when the content is clean.
+type Scanner interface {
+	Name() string
+	Scan(ctx context.Context, obj Object) (string, error)
+}
+
+// Finding is something a check flagged
+type Finding struct {
+	Check  string `json:"check"`
+	Reason string `json:"reason"`
+}
+
+func (f Finding) String() string { return f.Check + ": " + f.Reason }
+
+// Report is the outcome of scanning a file
+type Report struct {
+	Status string `json:"status"`
+	// Type is the sniffed content type
+	Type     string    `json:"type"`
+	Findings []Finding `json:"findings,omitempty"`
+	// Action is what happens to the file; empty when it is clean
+	Action Action `json:"action,omitempty"`
+	// Key is the key the file is stored under, the quarantined key for
+	// quarantined files
+	Key       string    `json:"key"`
+	ScannedAt time.Time `json:"scanned_at"`
+}
+
+// Err returns the error storing the file fails with: ErrRejected or
+// ErrQuarantined wrapped with the findings, or nil
+func (r *Report) Err() error {
+	var target error
+	switch r.Action {
+	case ActionReject:
+		target = ErrRejected
+	case ActionQuarantine:
+		target = ErrQuarantined
+	default:
+		return nil
+	}
+	return fmt.Errorf("%w: %s", target, r.findings())
+}
+
+func (r *Report) findings() string {
+	parts := make([]string, len(r.Findings))
+	for i, f := range r.Findings {
+		parts[i] = f.String()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Metadata returns the metadata entries recording the report
+func (r *Report) Metadata() map[string]string {
+	meta := map[string]string{
+		MetaStatus: r.Status,
+		MetaType:   r.Type,
+		MetaAt:     r.ScannedAt.UTC().Format(time.RFC3339),
+	}
+	if len(r.Findings) > 0 {
+		meta[MetaFindings] = truncate(r.findings(), 256)
+	}
+	if r.Action != "" {
+		meta[MetaAction] = string(r.Action)
+	}
+	return meta
+}
+
+// Apply merges the report into the tags and metadata of the file
+func (r *Report) Apply(tags []string, attrs map[string]string) ([]string, map[string]string) {
+	merged := make(map[string]string, len(attrs)+6)
+	for k, v := range attrs {
+		merged[k] = v
+	}
+	for k, v := range r.Metadata() {
+		merged[k] = v
+	}
+	if r.Action == ActionTag {
+		tags = append(append([]string{}, tags...), TagFlagged)
+	}
+	return tags, merged
+}
+
+// ParseAction parses the name of an action
+func ParseAction(name string) (Action, error) {
+	switch a := Action(strings.ToLower(strings.TrimSpace(name))); a {
+	case ActionReject, ActionQuarantine, ActionTag:
+		return a, nil
+	default:
+		return "", fmt.Errorf("scan: unknown action %q (want reject, quarantine or tag)", name)
+	}
+}
+
+// Options configures a Pipeline
+type Options struct {
+	// AllowTypes, when set, are the only content types accepted; DenyTypes
+	// are refused. Entries are MIME types or prefixes such as "image/*".
+	AllowTypes []string
+	DenyTypes  []string
+	// MaxSize flags files larger than this many bytes; zero disables it
+	MaxSize int64
+	// Scanners are the external scanners every file is passed to
+	Scanners []Scanner
+	// Action is what happens to flagged files; defaults to ActionReject
+	Action Action
+	// QuarantinePrefix is prepended to the key of quarantined files;
+	// defaults to DefaultQuarantinePrefix
+	QuarantinePrefix string
+	// FailOpen stores files a scanner failed to check, marked unscanned,
+	// instead of refusing them
+	FailOpen bool
+	// Timeout bounds each external scanner; defaults to DefaultTimeout
+	Timeout time.Duration
+}
+
+// Stats counts the files a Pipeline scanned
+type Stats struct {
+	Scanned     int64 `json:"scanned"`
+	Flagged     int64 `json:"flagged"`
+	Unscanned   int64 `json:"unscanned"`
+	Rejected    int64 `json:"rejected"`
+	Quarantined int64 `json:"quarantined"`
+}
+
+// Pipeline runs the checks on each stored file
+type Pipeline struct {
+	opts Options
+
+	scanned, flagged, unscanned, rejected, quarantined atomic.Int64
+}
+
+// New returns a Pipeline running the checks of opts
+func New(opts Options) *Pipeline {
+	if opts.Action == "" {
+		opts.Action = ActionReject
+	}
+	if opts.QuarantinePrefix == "" {
+		opts.QuarantinePrefix = DefaultQuarantinePrefix
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	return &Pipeline{opts: opts}
+}
+
+// Quarantined reports whether key is in the quarantine namespace
+func (p *Pipeline) Quarantined(key string) bool {
+	return strings.HasPrefix(key, p.opts.QuarantinePrefix)
+}
+
+// Scan runs every check on obj. A scanner that fails makes Scan fail with
+// ErrScannerFailed, unless the pipeline fails open.
+func (p *Pipeline) Scan(ctx context.Context, obj Object) (*Report, error) {
+	p.scanned.Add(1)
+	report := &Report{Status: StatusClean, Type: Sniff(obj.Data), Key: obj.Key, ScannedAt: time.Now()}
+
+	if p.opts.MaxSize > 0 && int64(len(obj.Data)) > p.opts.MaxSize {
+		report.Findings = append(report.Findings, Finding{Check: "size", Reason: fmt.Sprintf("%d bytes exceeds the limit of %d", len(obj.Data), p.opts.MaxSize)})
+	}
+	if matchType(p.opts.DenyTypes, report.Type) || (len(p.opts.AllowTypes) > 0 && !matchType(p.opts.AllowTypes, report.Type)) {
+		report.Findings = append(report.Findings, Finding{Check: "type", Reason: report.Type + " is not allowed"})
+	}
+
+	for _, scanner := range p.opts.Scanners {
+		sctx, cancel := context.WithTimeout(ctx, p.opts.Timeout)
+		found, err := scanner.Scan(sctx, obj)
+		cancel()
+		if err != nil {
+			if !p.opts.FailOpen {
+				return nil, fmt.Errorf("%w: %s: %v", ErrScannerFailed, scanner.Name(), err)
+			}
+			slog.Warn("scanner failed, storing the file unscanned", "scanner", scanner.Name(), "key", obj.Key, "error", err)
+			report.Status = StatusUnscanned
+			continue
+		}
+		if found != "" {
+			report.Findings = append(report.Findings, Finding{Check: scanner.Name(), Reason: found})
+		}
+	}
+	if report.Status == StatusUnscanned {
+		p.unscanned.Add(1)
+	}
+
+	if len(report.Findings) == 0 {
+		return report, nil
+	}
+	p.flagged.Add(1)
+	report.Status = StatusFlagged
+	report.Action = p.opts.Action
+	switch report.Action {
+	case ActionReject:
+		p.rejected.Add(1)
+	case ActionQuarantine:
+		p.quarantined.Add(1)
+		report.Key = p.opts.QuarantinePrefix + obj.Key
+	}
+	slog.Warn("file flagged by scan", "key", obj.Key, "findings", report.findings(), "action", report.Action)
+	return report, nil
+}
+
+// Stats returns the counts of files scanned so far
+func (p *Pipeline) Stats() Stats {
+	return Stats{
+		Scanned:     p.scanned.Load(),
+		Flagged:     p.flagged.Load(),
+		Unscanned:   p.unscanned.Load(),
+		Rejected:    p.rejected.Load(),
+		Quarantined: p.quarantined.Load(),
+	}
+}
+
+// signatures are executables and scripts http.DetectContentType does not
+// tell from other binary content
+var signatures = []struct {
+	prefix   string
+	mimeType string
+}{
+	{"MZ", "application/x-msdownload"},
+	{"\x7fELF", "application/x-executable"},
+	{"\xfe\xed\xfa\xce", "application/x-mach-binary"},
+	{"\xfe\xed\xfa\xcf", "application/x-mach-binary"},
+	{"\xce\xfa\xed\xfe", "application/x-mach-binary"},
+	{"\xcf\xfa\xed\xfe", "application/x-mach-binary"},
+	{"#!", "text/x-shellscript"},
+}
+
+// Sniff returns the MIME type of data, without parameters
+func Sniff(data []byte) string {
+	for _, sig := range signatures {
+		if bytes.HasPrefix(data, []byte(sig.prefix)) {
+			return sig.mimeType
+		}
+	}
+	mimeType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	return mimeType
+}
+
+// matchType reports whether mimeType matches one of patterns
+func matchType(patterns []string, mimeType string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(mimeType, prefix) {
+				return true
+			}
+		} else if pattern == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,244 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+type fakeScanner struct {
+	found string
+	err   error
+}
+
+func (f fakeScanner) Name() string { return "fake" }
+
+func (f fakeScanner) Scan(context.Context, Object) (string, error) { return f.found, f.err }
+
+func TestSniff(t *testing.T) {
+	assert.Equal(t, "application/x-msdownload", Sniff([]byte("MZ\x90\x00")))
+	assert.Equal(t, "application/x-executable", Sniff([]byte("\x7fELF\x02\x01")))
+	assert.Equal(t, "text/x-shellscript", Sniff([]byte("#!/bin/sh\nrm -rf /\n")))
+	assert.Equal(t, "text/plain", Sniff([]byte("hello")))
+	assert.Equal(t, "image/png", Sniff([]byte("\x89PNG\r\n\x1a\n")))
+}
+
+func TestPolicies(t *testing.T) {
+	ctx := context.Background()
+	p := New(Options{MaxSize: 8, DenyTypes: []string{"application/x-*"}})
+
+	report, err := p.Scan(ctx, Object{Key: "a.txt", Data: []byte("hello")})
+	require.NoError(t, err)
+	assert.Equal(t, StatusClean, report.Status)
+	assert.NoError(t, report.Err())
+
+	report, err = p.Scan(ctx, Object{Key: "big.txt", Data: []byte("hello world")})
+	require.NoError(t, err)
+	assert.Equal(t, StatusFlagged, report.Status)
+	assert.ErrorIs(t, report.Err(), ErrRejected)
+	assert.Equal(t, "size", report.Findings[0].Check)
+
+	report, err = p.Scan(ctx, Object{Key: "a.exe", Data: []byte("MZ")})
+	require.NoError(t, err)
+	assert.ErrorIs(t, report.Err(), ErrRejected)
+	assert.Equal(t, "type", report.Findings[0].Check)
+
+	allow := New(Options{AllowTypes: []string{"image/*"}})
+	report, err = allow.Scan(ctx, Object{Key: "a.txt", Data: []byte("hello")})
+	require.NoError(t, err)
+	assert.Equal(t, StatusFlagged, report.Status)
+
+	assert.Equal(t, Stats{Scanned: 3, Flagged: 2, Rejected: 2}, p.Stats())
+}
+
+func TestActions(t *testing.T) {
+	ctx := context.Background()
+	infected := []Scanner{fakeScanner{found: "Eicar-Test-Signature"}}
+
+	quarantine := New(Options{Scanners: infected, Action: ActionQuarantine})
+	report, err := quarantine.Scan(ctx, Object{Key: "docs/a.txt", Data: []byte(eicar)})
+	require.NoError(t, err)
+	assert.ErrorIs(t, report.Err(), ErrQuarantined)
+	assert.Equal(t, "quarantine/docs/a.txt", report.Key)
+	assert.True(t, quarantine.Quarantined(report.Key))
+	assert.Equal(t, "quarantine", report.Metadata()[MetaAction])
+
+	tag := New(Options{Scanners: infected, Action: ActionTag})
+	report, err = tag.Scan(ctx, Object{Key: "a.txt", Data: []byte(eicar)})
+	require.NoError(t, err)
+	assert.NoError(t, report.Err())
+	tags, attrs := report.Apply([]string{"docs"}, map[string]string{"owner": "alice"})
+	assert.Equal(t, []string{"docs", TagFlagged}, tags)
+	assert.Equal(t, "alice", attrs["owner"])
+	assert.Equal(t, StatusFlagged, attrs[MetaStatus])
+	assert.Equal(t, "fake: Eicar-Test-Signature", attrs[MetaFindings])
+
+	_, err = ParseAction("delete")
+	assert.Error(t, err)
+	action, err := ParseAction(" Tag ")
+	require.NoError(t, err)
+	assert.Equal(t, ActionTag, action)
+}
+
+func TestScannerFailure(t *testing.T) {
+	ctx := context.Background()
+	broken := []Scanner{fakeScanner{err: errors.New("connection refused")}}
+
+	_, err := New(Options{Scanners: broken}).Scan(ctx, Object{Key: "a.txt", Data: []byte("hello")})
+	assert.ErrorIs(t, err, ErrScannerFailed)
+
+	open := New(Options{Scanners: broken, FailOpen: true})
+	report, err := open.Scan(ctx, Object{Key: "a.txt", Data: []byte("hello")})
+	require.NoError(t, err)
+	assert.Equal(t, StatusUnscanned, report.Status)
+	assert.NoError(t, report.Err())
+	assert.Equal(t, int64(1), open.Stats().Unscanned)
+}
+
+// keywordPlugin is the scanner of a plugin flagging files containing a
+// keyword
+type keywordPlugin struct{ keyword string }
+
+func (k keywordPlugin) Scan(ctx context.Context, key string, data []byte) (string, error) {
+	if strings.Contains(string(data), k.keyword) {
+		return "contains " + k.keyword + " in " + key, nil
+	}
+	return "", nil
+}
+
+func TestPluginScanner(t *testing.T) {
+	ctx := context.Background()
+	p := New(Options{Scanners: []Scanner{NewPlugin("deny-list", keywordPlugin{keyword: "secret"})}, Action: ActionTag})
+
+	report, err := p.Scan(ctx, Object{Key: "notes.txt", Data: []byte("top secret")})
+	require.NoError(t, err)
+	assert.Equal(t, StatusFlagged, report.Status)
+	assert.Equal(t, "deny-list: contains secret in notes.txt", report.Metadata()[MetaFindings])
+
+	report, err = p.Scan(ctx, Object{Key: "readme.txt", Data: []byte("hello")})
+	require.NoError(t, err)
+	assert.NotEqual(t, StatusFlagged, report.Status)
+}
+
+// serve accepts connections on a local listener and hands each to handle
+func serve(t *testing.T, handle func(conn net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			handle(conn)
+			_ = conn.Close()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestClamd(t *testing.T) {
+	addr := serve(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		if cmd, err := r.ReadString(0); err != nil || cmd != "zINSTREAM\x00" {
+			return
+		}
+		var data []byte
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			chunk := make([]byte, size)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return
+			}
+			data = append(data, chunk...)
+		}
+		reply := "stream: OK\x00"
+		if strings.Contains(string(data), "EICAR") {
+			reply = "stream: Eicar-Test-Signature FOUND\x00"
+		}
+		_, _ = conn.Write([]byte(reply))
+	})
+
+	c := NewClamd(addr)
+	found, err := c.Scan(context.Background(), Object{Key: "a.txt", Data: []byte("hello")})
+	require.NoError(t, err)
+	assert.Empty(t, found)
+
+	found, err = c.Scan(context.Background(), Object{Key: "a.txt", Data: append(make([]byte, clamdChunkSize), eicar...)})
+	require.NoError(t, err)
+	assert.Equal(t, "Eicar-Test-Signature", found)
+
+	_, err = parseClamdReply("INSTREAM size limit exceeded. ERROR\x00")
+	assert.Error(t, err)
+}
+
+func TestICAP(t *testing.T) {
+	addr := serve(t, func(conn net.Conn) {
+		r := textproto.NewReader(bufio.NewReader(conn))
+		if _, err := r.ReadLine(); err != nil {
+			return
+		}
+		if _, err := r.ReadMIMEHeader(); err != nil {
+			return
+		}
+		// The encapsulated response header, then the chunked body
+		if _, err := r.ReadLine(); err != nil {
+			return
+		}
+		if _, err := r.ReadMIMEHeader(); err != nil {
+			return
+		}
+		var body strings.Builder
+		for {
+			line, err := r.ReadLine()
+			if err != nil || line == "0" {
+				break
+			}
+			chunk, err := r.ReadLine()
+			if err != nil {
+				return
+			}
+			body.WriteString(chunk)
+		}
+		reply := "ICAP/1.0 204 No Content\r\nISTag: \"test\"\r\n\r\n"
+		if strings.Contains(body.String(), "EICAR") {
+			reply = "ICAP/1.0 200 OK\r\nISTag: \"test\"\r\nX-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Test-Signature;\r\nEncapsulated: null-body=0\r\n\r\n"
+		}
+		_, _ = conn.Write([]byte(reply))
+	})
+
+	_, err := NewICAP("http://" + addr)
+	assert.Error(t, err)
+	i, err := NewICAP("icap://" + addr + "/avscan")
+	require.NoError(t, err)
+
+	found, err := i.Scan(context.Background(), Object{Key: "a.txt", Data: []byte("hello")})
+	require.NoError(t, err)
+	assert.Empty(t, found)
+
+	found, err = i.Scan(context.Background(), Object{Key: "a.txt", Data: []byte(eicar)})
+	require.NoError(t, err)
+	assert.Equal(t, "Eicar-Test-Signature", found)
+
+	_, err = parseICAPReply("ICAP/1.0 500 Server Error", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,615 @@
+// Package scheduler runs the periodic work of a node, such as reports, key
+// rotation, garbage collection, lifecycle rules and backups, on cron
+// schedules. Subsystems register jobs with a default schedule. Operators
+// change the schedule of a job or pause it, which persists, and run jobs
+// by hand. Runs of a job never overlap, scheduled runs are spread by a
+// random jitter, and the outcome of the recent runs of each job is kept.
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHistory is how many runs of each job are kept
+const DefaultHistory = 20
+
+var (
+	// ErrInvalid is returned for jobs with a malformed or taken name, no
+	// work or a malformed schedule
+	ErrInvalid = errors.New("scheduler: invalid job")
+	// ErrNotFound is returned for jobs that are not registered
+	ErrNotFound = errors.New("scheduler: job not found")
+	// ErrRunning is returned for jobs triggered while they run
+	ErrRunning = errors.New("scheduler: job is already running")
+	// ErrSkipped is returned by the work of jobs that had nothing to do
+	// this time, which records the run as skipped rather than failed
+	ErrSkipped = errors.New("scheduler: run skipped")
+)
+
+// validName matches job names, such as "reports" or "backup.nightly"; they
+// are single segments of API paths
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// Trigger is what started a run
+type Trigger string
+
+const (
+	// Scheduled runs were due on the schedule of their job
+	Scheduled Trigger = "scheduled"
+	// Manual runs were triggered through the API
+	Manual Trigger = "manual"
+)
+
+// Status is how a run is doing
+type Status string
+
+const (
+	Running   Status = "running"
+	Succeeded Status = "succeeded"
+	Failed    Status = "failed"
+	// Skipped runs were due while the previous run of the job still ran,
+	// or had nothing to do
+	Skipped Status = "skipped"
+)
+
+// Job is periodic work of a subsystem
+type Job struct {
+	// Name identifies the job: letters, digits, '.', '_' and '-'
+	Name        string
+	Description string
+	// Schedule is the cron expression the job runs on unless changed; empty
+	// runs it only when triggered
+	Schedule string
+	// Jitter delays scheduled runs by a random duration up to it
+	Jitter time.Duration
+	// Run does the work; ctx ends when the scheduler stops
+	Run func(ctx context.Context) error
+	// Last optionally reports when the work last ran, for work that also
+	// runs outside the scheduler; "@every" schedules count from it
+	Last func() time.Time
+}
+
+// Run is one run of a job
+type Run struct {
+	ID         string    `json:"id"`
+	Job        string    `json:"job"`
+	Trigger    Trigger   `json:"trigger"`
+	Status     Status    `json:"status"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// JobStatus is a job with its schedule and last run
+type JobStatus struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Schedule is the schedule the job runs on, DefaultSchedule the one
+	// it registered with
+	Schedule        string    `json:"schedule,omitempty"`
+	DefaultSchedule string    `json:"default_schedule,omitempty"`
+	Jitter          string    `json:"jitter,omitempty"`
+	Paused          bool      `json:"paused"`
+	Running         bool      `json:"running"`
+	NextRun         time.Time `json:"next_run,omitempty"`
+	LastRun         *Run      `json:"last_run,omitempty"`
+}
+
+// Options configures a scheduler
+type Options struct {
+	// Path persists changed schedules, paused jobs and run history; empty
+	// keeps them in memory
+	Path string
+	// History is how many runs of each job are kept; zero uses
+	// DefaultHistory
+	History int
+}
+
+// jobState is what is persisted of a job, also for jobs that are not
+// registered yet
+type jobState struct {
+	// Schedule replaces the default schedule when set
+	Schedule string `json:"schedule,omitempty"`
+	Paused   bool   `json:"paused,omitempty"`
+	// History holds the recent runs, oldest first
+	History []Run `json:"history,omitempty"`
+}
+
+// state is how the scheduler is persisted
+type state struct {
+	Jobs map[string]*jobState `json:"jobs"`
+}
+
+// entry is a registered job
+type entry struct {
+	job Job
+	// cron is the schedule the job runs on, nil for jobs only triggered
+	cron    *Cron
+	next    time.Time
+	running bool
+}
+
+// Scheduler runs registered jobs on their schedules and on demand
+type Scheduler struct {
+	opts   Options
+	now    func() time.Time
+	jitter func(max time.Duration) time.Duration
+	ctx    context.Context
+	cancel context.CancelFunc
+	runs   sync.WaitGroup
+
+	mu     sync.Mutex
+	jobs   map[string]*entry
+	states map[string]*jobState
+	dirty  bool
+	// wake replans the loop after jobs or schedules changed
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a scheduler without jobs, which runs none on their schedules
+// until it is started
+func New(opts Options) *Scheduler {
+	if opts.History <= 0 {
+		opts.History = DefaultHistory
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		opts:   opts,
+		now:    time.Now,
+		jitter: randomJitter,
+		ctx:    ctx,
+		cancel: cancel,
+		jobs:   make(map[string]*entry),
+		states: make(map[string]*jobState),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+func randomJitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return mathrand.N(max)
+}
+
+// Register adds a job. A schedule it was changed to or a pause that
+// persisted carries over.
+func (s *Scheduler) Register(job Job) error {
+	if !validName.MatchString(job.Name) {
+		return fmt.Errorf("%w: malformed name %q", ErrInvalid, job.Name)
+	}
+	if job.Run == nil {
+		return fmt.Errorf("%w: %s has nothing to run", ErrInvalid, job.Name)
+	}
+	if job.Schedule != "" {
+		if _, err := ParseCron(job.Schedule); err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrInvalid, job.Name, err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[job.Name]; ok {
+		return fmt.Errorf("%w: %s is already registered", ErrInvalid, job.Name)
+	}
+	e := &entry{job: job}
+	s.jobs[job.Name] = e
+	s.resolveLocked(e)
+	s.wakeLocked()
+	return nil
+}
+
+// Unregister removes a job; a run in progress finishes on its own
+func (s *Scheduler) Unregister(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, name)
+}
+
+// resolveLocked parses the schedule a job runs on, falling back to its
+// default when the one it was changed to no longer parses
+func (s *Scheduler) resolveLocked(e *entry) {
+	e.cron, e.next = nil, time.Time{}
+	expr := e.job.Schedule
+	if st := s.states[e.job.Name]; st != nil && st.Schedule != "" {
+		expr = st.Schedule
+	}
+	if expr == "" {
+		return
+	}
+	cron, err := ParseCron(expr)
+	if err != nil {
+		slog.Warn("ignoring invalid job schedule", "job", e.job.Name, "schedule", expr, "error", err)
+		cron, _ = ParseCron(e.job.Schedule)
+	}
+	e.cron = cron
+}
+
+func (s *Scheduler) stateLocked(name string) *jobState {
+	st := s.states[name]
+	if st == nil {
+		st = &jobState{}
+		s.states[name] = st
+	}
+	return st
+}
+
+func (s *Scheduler) wakeLocked() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Jobs returns the registered jobs, sorted by name
+func (s *Scheduler) Jobs() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]JobStatus, 0, len(s.jobs))
+	for _, e := range s.jobs {
+		jobs = append(jobs, s.statusLocked(e))
+	}
+	slices.SortFunc(jobs, func(a, b JobStatus) int { return strings.Compare(a.Name, b.Name) })
+	return jobs
+}
+
+// Job returns a job and its recent runs, newest first
+func (s *Scheduler) Job(name string) (JobStatus, []Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.jobs[name]
+	if !ok {
+		return JobStatus{}, nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	var runs []Run
+	if st := s.states[name]; st != nil {
+		runs = slices.Clone(st.History)
+		slices.Reverse(runs)
+	}
+	return s.statusLocked(e), runs, nil
+}
+
+func (s *Scheduler) statusLocked(e *entry) JobStatus {
+	js := JobStatus{
+		Name:            e.job.Name,
+		Description:     e.job.Description,
+		DefaultSchedule: e.job.Schedule,
+		Running:         e.running,
+	}
+	if e.cron != nil {
+		js.Schedule = e.cron.String()
+		js.NextRun = e.next
+	}
+	if e.job.Jitter > 0 {
+		js.Jitter = e.job.Jitter.String()
+	}
+	if st := s.states[e.job.Name]; st != nil {
+		js.Paused = st.Paused
+		if n := len(st.History); n > 0 {
+			last := st.History[n-1]
+			js.LastRun = &last
+		}
+	}
+	if js.Paused {
+		js.NextRun = time.Time{}
+	}
+	return js
+}
+
+// Trigger runs a job now, in the background, returning its run
+func (s *Scheduler) Trigger(name string) (Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.jobs[name]
+	if !ok {
+		return Run{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if e.running {
+		return Run{}, fmt.Errorf("%w: %s", ErrRunning, name)
+	}
+	return s.startLocked(e, Manual, s.now()), nil
+}
+
+// SetSchedule changes the schedule of a job; an empty one restores its
+// default
+func (s *Scheduler) SetSchedule(name, expr string) (JobStatus, error) {
+	expr = strings.TrimSpace(expr)
+	if expr != "" {
+		if _, err := ParseCron(expr); err != nil {
+			return JobStatus{}, fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.jobs[name]
+	if !ok {
+		return JobStatus{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if expr == e.job.Schedule {
+		expr = ""
+	}
+	s.stateLocked(name).Schedule = expr
+	s.resolveLocked(e)
+	s.dirty = true
+	s.wakeLocked()
+	return s.statusLocked(e), nil
+}
+
+// Pause stops or resumes the scheduled runs of a job; it still runs when
+// triggered
+func (s *Scheduler) Pause(name string, paused bool) (JobStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.jobs[name]
+	if !ok {
+		return JobStatus{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	s.stateLocked(name).Paused = paused
+	e.next = time.Time{}
+	s.dirty = true
+	s.wakeLocked()
+	return s.statusLocked(e), nil
+}
+
+// Start runs the jobs on their schedules until Stop is called
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	if s.stop != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	stop, done := s.stop, s.done
+	s.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		for {
+			timer := time.NewTimer(s.tick(s.now()))
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-s.wake:
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// Stop stops scheduling, cancels the runs in progress and waits for them
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	s.cancel()
+	s.runs.Wait()
+}
+
+// tick starts the jobs that are due and returns how long to wait for the
+// next one
+func (s *Scheduler) tick(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var earliest time.Time
+	for name, e := range s.jobs {
+		if e.cron == nil || s.stateLocked(name).Paused {
+			continue
+		}
+		if e.next.IsZero() {
+			e.next = s.firstRunLocked(e, now)
+		}
+		if !e.next.After(now) {
+			if due := s.intervalDueLocked(e); due.After(now) {
+				// The work ran outside the scheduler in the meantime
+				e.next = due
+			} else {
+				if e.running {
+					s.recordLocked(Run{
+						ID: newID(), Job: name, Trigger: Scheduled, Status: Skipped, StartedAt: now, FinishedAt: now,
+						Error: "the previous run is still running",
+					})
+					slog.Info("skipping job, the previous run is still running", "job", name)
+				} else {
+					s.startLocked(e, Scheduled, now)
+				}
+				e.next = e.cron.Next(now).Add(s.jitter(e.job.Jitter))
+			}
+		}
+		if !e.next.IsZero() && (earliest.IsZero() || e.next.Before(earliest)) {
+			earliest = e.next
+		}
+	}
+	if earliest.IsZero() {
+		return time.Hour
+	}
+	return max(earliest.Sub(now), 0)
+}
+
+// firstRunLocked returns when a job first runs after the scheduler
+// planned it. Intervals count from the last run, so jobs overdue run at
+// once.
+func (s *Scheduler) firstRunLocked(e *entry, now time.Time) time.Time {
+	next := e.cron.Next(now)
+	if due := s.intervalDueLocked(e); !due.IsZero() {
+		next = due
+	}
+	if next.IsZero() {
+		return next
+	}
+	return next.Add(s.jitter(e.job.Jitter))
+}
+
+// intervalDueLocked returns when an "@every" job is due after its last
+// run, zero for other jobs and those that never ran
+func (s *Scheduler) intervalDueLocked(e *entry) time.Time {
+	every := e.cron.Every()
+	if every == 0 {
+		return time.Time{}
+	}
+	var last time.Time
+	if e.job.Last != nil {
+		last = e.job.Last()
+	}
+	if st := s.states[e.job.Name]; st != nil {
+		for _, r := range st.History {
+			if r.Status != Skipped && r.StartedAt.After(last) {
+				last = r.StartedAt
+			}
+		}
+	}
+	if last.IsZero() {
+		return time.Time{}
+	}
+	return last.Add(every)
+}
+
+// startLocked runs a job in the background, recording the run
+func (s *Scheduler) startLocked(e *entry, trigger Trigger, now time.Time) Run {
+	run := Run{ID: newID(), Job: e.job.Name, Trigger: trigger, Status: Running, StartedAt: now}
+	s.recordLocked(run)
+	e.running = true
+	s.runs.Add(1)
+	go func() {
+		defer s.runs.Done()
+		err := runJob(s.ctx, e.job)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		e.running = false
+		finished := run
+		finished.Status, finished.FinishedAt = Succeeded, s.now()
+		switch {
+		case errors.Is(err, ErrSkipped):
+			finished.Status, finished.Error = Skipped, err.Error()
+		case err != nil:
+			finished.Status, finished.Error = Failed, err.Error()
+			slog.Warn("job failed", "job", e.job.Name, "trigger", trigger, "error", err)
+		}
+		s.recordLocked(finished)
+	}()
+	return run
+}
+
+// runJob runs the work of a job, failing it when it panics
+func runJob(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.Run(ctx)
+}
+
+// recordLocked adds a run to the history of its job, or updates it,
+// dropping the oldest runs beyond the history kept
+func (s *Scheduler) recordLocked(run Run) {
+	st := s.stateLocked(run.Job)
+	s.dirty = true
+	if i := slices.IndexFunc(st.History, func(r Run) bool { return r.ID == run.ID }); i >= 0 {
+		st.History[i] = run
+		return
+	}
+	st.History = append(st.History, run)
+	if extra := len(st.History) - s.opts.History; extra > 0 {
+		st.History = slices.Delete(st.History, 0, extra)
+	}
+}
+
+// Flush persists the changed schedules, paused jobs and run history if
+// they changed since the last flush
+func (s *Scheduler) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty || s.opts.Path == "" {
+		return nil
+	}
+	data, err := json.Marshal(state{Jobs: s.states})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.opts.Path), 0700); err != nil {
+		return err
+	}
+	tmp := s.opts.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.opts.Path); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// Load reads the persisted schedules, paused jobs and run history. Runs
+// that were in progress when it was persisted are recorded as failed.
+func (s *Scheduler) Load() error {
+	if s.opts.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("scheduler: corrupt state %s: %w", s.opts.Path, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, loaded := range st.Jobs {
+		if loaded == nil {
+			continue
+		}
+		for i, r := range loaded.History {
+			if r.Status == Running {
+				loaded.History[i].Status, loaded.History[i].Error = Failed, "interrupted by a restart"
+			}
+		}
+		current := s.stateLocked(name)
+		if current.Schedule == "" {
+			current.Schedule = loaded.Schedule
+		}
+		current.Paused = current.Paused || loaded.Paused
+		current.History = append(loaded.History, current.History...)
+		if extra := len(current.History) - s.opts.History; extra > 0 {
+			current.History = slices.Delete(current.History, 0, extra)
+		}
+		if e, ok := s.jobs[name]; ok {
+			s.resolveLocked(e)
+		}
+	}
+	s.wakeLocked()
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,280 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clock is a settable time for driving the scheduler by hand
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+func newTestScheduler(opts Options) (*Scheduler, *clock) {
+	c := &clock{now: time.Date(2026, 3, 14, 10, 7, 30, 0, time.UTC)}
+	s := New(opts)
+	s.now = c.Now
+	s.jitter = func(time.Duration) time.Duration { return 0 }
+	return s, c
+}
+
+// settle waits until no job runs
+func settle(t *testing.T, s *Scheduler) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		for _, j := range s.Jobs() {
+			if j.Running {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestParseCronEvery(t *testing.T) {
+	c, err := ParseCron("@every 90m")
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Minute, c.Every())
+	base := time.Date(2026, 3, 14, 10, 7, 30, 0, time.UTC)
+	assert.Equal(t, base.Add(90*time.Minute), c.Next(base))
+	assert.Equal(t, "@every 90m", c.String())
+
+	for _, expr := range []string{"@every", "@every 10s", "@every soon"} {
+		_, err := ParseCron(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestSchedulerRunsDueJobs(t *testing.T) {
+	s, c := newTestScheduler(Options{})
+	var mu sync.Mutex
+	runs := 0
+	require.NoError(t, s.Register(Job{Name: "reports", Schedule: "*/15 * * * *", Run: func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		runs++
+		return nil
+	}}))
+	require.NoError(t, s.Register(Job{Name: "gc", Schedule: "@hourly", Run: func(ctx context.Context) error {
+		return errors.New("disk on fire")
+	}}))
+
+	// Nothing is due yet; the next job fires at 10:15
+	assert.Equal(t, 7*time.Minute+30*time.Second, s.tick(c.Now()))
+	s.tick(c.Advance(8 * time.Minute))
+	settle(t, s)
+	mu.Lock()
+	assert.Equal(t, 1, runs)
+	mu.Unlock()
+
+	s.tick(c.Advance(time.Hour))
+	settle(t, s)
+	status, history, err := s.Job("gc")
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, Failed, history[0].Status)
+	assert.Equal(t, "disk on fire", history[0].Error)
+	assert.Equal(t, Scheduled, history[0].Trigger)
+	assert.Equal(t, time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC), status.NextRun)
+
+	_, _, err = s.Job("missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, s.Register(Job{Name: "gc", Run: func(context.Context) error { return nil }}), ErrInvalid)
+	assert.ErrorIs(t, s.Register(Job{Name: "Bad Name", Run: func(context.Context) error { return nil }}), ErrInvalid)
+	assert.ErrorIs(t, s.Register(Job{Name: "bad-schedule", Schedule: "61 * * * *", Run: func(context.Context) error { return nil }}), ErrInvalid)
+}
+
+func TestSchedulerPreventsOverlap(t *testing.T) {
+	s, c := newTestScheduler(Options{})
+	release := make(chan struct{})
+	started := make(chan struct{}, 4)
+	require.NoError(t, s.Register(Job{Name: "slow", Schedule: "* * * * *", Run: func(ctx context.Context) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}}))
+	s.tick(c.Now())
+
+	run, err := s.Trigger("slow")
+	require.NoError(t, err)
+	assert.Equal(t, Manual, run.Trigger)
+	<-started
+	_, err = s.Trigger("slow")
+	assert.ErrorIs(t, err, ErrRunning)
+
+	// A scheduled run due while the job runs is skipped
+	s.tick(c.Advance(time.Minute))
+	status, history, err := s.Job("slow")
+	require.NoError(t, err)
+	assert.True(t, status.Running)
+	require.Len(t, history, 2)
+	assert.Equal(t, Skipped, history[0].Status)
+	assert.Equal(t, Running, history[1].Status)
+
+	close(release)
+	settle(t, s)
+	_, history, _ = s.Job("slow")
+	assert.Equal(t, Succeeded, history[1].Status)
+	assert.Len(t, started, 0)
+}
+
+func TestSchedulerIntervalsCountFromLastRun(t *testing.T) {
+	s, c := newTestScheduler(Options{})
+	last := c.Now().Add(-50 * time.Minute)
+	var mu sync.Mutex
+	runs := 0
+	require.NoError(t, s.Register(Job{
+		Name:     "key-rotation",
+		Schedule: "@every 1h",
+		Last: func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return last
+		},
+		Run: func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			runs++
+			last = c.Now()
+			return nil
+		},
+	}))
+
+	assert.Equal(t, 10*time.Minute, s.tick(c.Now()))
+
+	// The work ran outside the scheduler, which pushes the next run back
+	mu.Lock()
+	last = c.Advance(5 * time.Minute)
+	mu.Unlock()
+	assert.Equal(t, time.Hour-5*time.Minute, s.tick(c.Advance(5*time.Minute)))
+	mu.Lock()
+	assert.Equal(t, 0, runs)
+	mu.Unlock()
+
+	s.tick(c.Advance(time.Hour))
+	settle(t, s)
+	mu.Lock()
+	assert.Equal(t, 1, runs)
+	mu.Unlock()
+}
+
+func TestSchedulerPauseAndReschedule(t *testing.T) {
+	s, c := newTestScheduler(Options{})
+	ran := make(chan struct{}, 4)
+	require.NoError(t, s.Register(Job{Name: "backup.nightly", Schedule: "30 2 * * *", Run: func(ctx context.Context) error {
+		ran <- struct{}{}
+		return nil
+	}}))
+
+	status, err := s.SetSchedule("backup.nightly", "*/10 * * * *")
+	require.NoError(t, err)
+	assert.Equal(t, "*/10 * * * *", status.Schedule)
+	assert.Equal(t, "30 2 * * *", status.DefaultSchedule)
+	_, err = s.SetSchedule("backup.nightly", "not a schedule")
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, err = s.SetSchedule("missing", "")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	status, err = s.Pause("backup.nightly", true)
+	require.NoError(t, err)
+	assert.True(t, status.Paused)
+	assert.True(t, status.NextRun.IsZero())
+	s.tick(c.Advance(time.Hour))
+	assert.Len(t, ran, 0)
+
+	// Paused jobs still run when triggered
+	_, err = s.Trigger("backup.nightly")
+	require.NoError(t, err)
+	<-ran
+	settle(t, s)
+
+	_, err = s.Pause("backup.nightly", false)
+	require.NoError(t, err)
+	s.tick(c.Now())
+	s.tick(c.Advance(10 * time.Minute))
+	<-ran
+	settle(t, s)
+
+	status, err = s.SetSchedule("backup.nightly", "")
+	require.NoError(t, err)
+	assert.Equal(t, "30 2 * * *", status.Schedule)
+}
+
+func TestSchedulerPersistsState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedules.json")
+	s, c := newTestScheduler(Options{Path: path, History: 2})
+	job := Job{Name: "edge-gc", Schedule: "@every 5m", Run: func(ctx context.Context) error { return nil }}
+	require.NoError(t, s.Register(job))
+	_, err := s.SetSchedule("edge-gc", "@every 10m")
+	require.NoError(t, err)
+	_, err = s.Pause("edge-gc", true)
+	require.NoError(t, err)
+	for range 3 {
+		_, err := s.Trigger("edge-gc")
+		require.NoError(t, err)
+		settle(t, s)
+		c.Advance(time.Minute)
+	}
+	require.NoError(t, s.Flush())
+
+	// State loaded before jobs register applies when they do
+	loaded, _ := newTestScheduler(Options{Path: path, History: 2})
+	require.NoError(t, loaded.Load())
+	require.NoError(t, loaded.Register(job))
+	status, history, err := loaded.Job("edge-gc")
+	require.NoError(t, err)
+	assert.Equal(t, "@every 10m", status.Schedule)
+	assert.True(t, status.Paused)
+	require.Len(t, history, 2)
+	assert.Equal(t, Succeeded, history[0].Status)
+	assert.Equal(t, Manual, history[0].Trigger)
+}
+
+func TestSchedulerStopCancelsRuns(t *testing.T) {
+	s := New(Options{})
+	started := make(chan struct{})
+	require.NoError(t, s.Register(Job{Name: "wait", Run: func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}}))
+	require.NoError(t, s.Register(Job{Name: "panics", Run: func(ctx context.Context) error {
+		panic("boom")
+	}}))
+	s.Start()
+	_, err := s.Trigger("wait")
+	require.NoError(t, err)
+	<-started
+	_, err = s.Trigger("panics")
+	require.NoError(t, err)
+	s.Stop()
+
+	_, history, err := s.Job("wait")
+	require.NoError(t, err)
+	assert.Equal(t, Failed, history[0].Status)
+	assert.Equal(t, context.Canceled.Error(), history[0].Error)
+	_, history, err = s.Job("panics")
+	require.NoError(t, err)
+	assert.Equal(t, "panic: boom", history[0].Error)
+}
@@ -0,0 +1,265 @@
+package search
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"html"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxDocumentSize bounds how much of a document is read for extraction
+const MaxDocumentSize = 16 << 20
+
+// ErrUnsupportedFormat is returned for documents text cannot be extracted from
+var ErrUnsupportedFormat = errors.New("search: unsupported document format")
+
+// Format is a document format text can be extracted from
+type Format string
+
+const (
+	FormatText     Format = "text"
+	FormatMarkdown Format = "markdown"
+	FormatHTML     Format = "html"
+	FormatPDF      Format = "pdf"
+)
+
+// DetectFormat picks the extraction format from the content type, falling
+// back to the file extension. It returns "" for unsupported documents.
+func DetectFormat(name, contentType string) Format {
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	switch strings.TrimSpace(mediaType) {
+	case "text/plain":
+		// Browsers and clients often send text/plain for markdown
+		if f := formatFromExt(name); f == FormatMarkdown {
+			return f
+		}
+		return FormatText
+	case "text/markdown", "text/x-markdown":
+		return FormatMarkdown
+	case "text/html", "application/xhtml+xml":
+		return FormatHTML
+	case "application/pdf":
+		return FormatPDF
+	}
+	return formatFromExt(name)
+}
+
+func formatFromExt(name string) Format {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".txt", ".text", ".log", ".csv":
+		return FormatText
+	case ".md", ".markdown":
+		return FormatMarkdown
+	case ".html", ".htm", ".xhtml":
+		return FormatHTML
+	case ".pdf":
+		return FormatPDF
+	}
+	return ""
+}
+
+// Extract returns the plain text of a document
+func Extract(format Format, r io.Reader) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxDocumentSize))
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case FormatText:
+		return toValidText(data), nil
+	case FormatMarkdown:
+		return extractMarkdown(toValidText(data)), nil
+	case FormatHTML:
+		return extractHTML(toValidText(data)), nil
+	case FormatPDF:
+		return extractPDF(data)
+	default:
+		return "", ErrUnsupportedFormat
+	}
+}
+
+func toValidText(data []byte) string {
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	return strings.ToValidUTF8(string(data), " ")
+}
+
+var (
+	mdImage     = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLink      = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	mdHeading   = regexp.MustCompile(`(?m)^\s{0,3}#{1,6}\s*`)
+	mdQuote     = regexp.MustCompile(`(?m)^\s*>\s?`)
+	mdListItem  = regexp.MustCompile(`(?m)^\s*(?:[-*+]|\d+\.)\s+`)
+	mdFence     = regexp.MustCompile("(?m)^\\s*(```|~~~).*$")
+	mdEmphasis  = regexp.MustCompile("[*_`~]+")
+	mdHTMLTag   = regexp.MustCompile(`<[^>]+>`)
+	htmlNoText  = regexp.MustCompile(`(?is)<(script|style|noscript|template)\b.*?</(script|style|noscript|template)\s*>`)
+	htmlComment = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlBlock   = regexp.MustCompile(`(?i)</?(p|div|br|li|tr|h[1-6]|section|article|header|footer|title)\b[^>]*>`)
+	htmlTag     = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankLines  = regexp.MustCompile(`\n\s*\n+`)
+	spaces      = regexp.MustCompile(`[ \t\r\f\v]+`)
+)
+
+func extractMarkdown(text string) string {
+	text = mdFence.ReplaceAllString(text, "")
+	text = mdImage.ReplaceAllString(text, "$1")
+	text = mdLink.ReplaceAllString(text, "$1")
+	text = mdHeading.ReplaceAllString(text, "")
+	text = mdQuote.ReplaceAllString(text, "")
+	text = mdListItem.ReplaceAllString(text, "")
+	text = mdHTMLTag.ReplaceAllString(text, "")
+	text = mdEmphasis.ReplaceAllString(text, "")
+	return normalizeSpace(text)
+}
+
+func extractHTML(text string) string {
+	text = htmlComment.ReplaceAllString(text, "")
+	text = htmlNoText.ReplaceAllString(text, "")
+	text = htmlBlock.ReplaceAllString(text, "\n")
+	text = htmlTag.ReplaceAllString(text, "")
+	return normalizeSpace(html.UnescapeString(text))
+}
+
+func normalizeSpace(text string) string {
+	text = spaces.ReplaceAllString(text, " ")
+	text = blankLines.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
+
+var (
+	pdfStream = regexp.MustCompile(`(?s)<<(.*?)>>\s*stream\r?\n`)
+	pdfTextOp = regexp.MustCompile(`(?s)BT(.*?)ET`)
+)
+
+// extractPDF pulls text out of PDF content streams. It understands
+// uncompressed and FlateDecode streams and the Tj, TJ, ' and " text
+// operators, which covers documents produced by common writers; text drawn
+// through custom font encodings comes out as-is.
+func extractPDF(data []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, " \r\n\t"), []byte("%PDF")) {
+		return "", errors.New("search: not a PDF document")
+	}
+
+	var out strings.Builder
+	// Compressed streams inflate within MaxDocumentSize between them, so
+	// many small streams cannot inflate to many times the bound
+	inflateBudget := int64(MaxDocumentSize)
+	for _, loc := range pdfStream.FindAllSubmatchIndex(data, -1) {
+		dict := data[loc[2]:loc[3]]
+		start := loc[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			continue
+		}
+		content := data[start : start+end]
+
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			if inflateBudget <= 0 {
+				break
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				continue
+			}
+			inflated, err := io.ReadAll(io.LimitReader(zr, inflateBudget))
+			zr.Close()
+			inflateBudget -= int64(len(inflated))
+			if err != nil && len(inflated) == 0 {
+				continue
+			}
+			content = inflated
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			// Images and other encodings carry no text
+			continue
+		}
+
+		for _, block := range pdfTextOp.FindAllSubmatch(content, -1) {
+			extractPDFText(block[1], &out)
+			out.WriteByte('\n')
+		}
+	}
+	return normalizeSpace(out.String()), nil
+}
+
+// extractPDFText writes the literal strings shown by a BT/ET block
+func extractPDFText(block []byte, out *strings.Builder) {
+	for i := 0; i < len(block); i++ {
+		switch block[i] {
+		case '(':
+			s, next := readPDFString(block, i+1)
+			out.WriteString(s)
+			i = next
+		case ']':
+			// End of a TJ array; separate it from the next show operator
+			out.WriteByte(' ')
+		case 'T':
+			if i+1 < len(block) && (block[i+1] == 'j' || block[i+1] == '*' || block[i+1] == 'd' || block[i+1] == 'D') {
+				out.WriteByte(' ')
+			}
+		case '\'', '"':
+			out.WriteByte('\n')
+		}
+	}
+}
+
+// readPDFString decodes a literal string starting after its opening
+// parenthesis and returns the index of the closing one
+func readPDFString(b []byte, i int) (string, int) {
+	var s strings.Builder
+	depth := 1
+	for ; i < len(b); i++ {
+		c := b[i]
+		switch c {
+		case '\\':
+			i++
+			if i >= len(b) {
+				return s.String(), i
+			}
+			switch e := b[i]; e {
+			case 'n':
+				s.WriteByte('\n')
+			case 'r':
+				s.WriteByte('\r')
+			case 't':
+				s.WriteByte('\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// Line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					v, n := 0, 0
+					for n < 3 && i < len(b) && b[i] >= '0' && b[i] <= '7' {
+						v = v*8 + int(b[i]-'0')
+						i++
+						n++
+					}
+					i--
+					s.WriteRune(rune(v))
+				} else {
+					s.WriteRune(rune(e))
+				}
+			}
+		case '(':
+			depth++
+			s.WriteByte(c)
+		case ')':
+			depth--
+			if depth == 0 {
+				return s.String(), i
+			}
+			s.WriteByte(c)
+		default:
+			// Bytes outside ASCII are treated as Latin-1
+			s.WriteRune(rune(c))
+		}
+	}
+	return s.String(), i
+}
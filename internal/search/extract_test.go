@@ -0,0 +1,95 @@
+package search
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectFormat(t *testing.T) {
+	assert.Equal(t, FormatText, DetectFormat("notes.txt", ""))
+	assert.Equal(t, FormatMarkdown, DetectFormat("README.md", "text/plain; charset=utf-8"))
+	assert.Equal(t, FormatHTML, DetectFormat("page", "text/html; charset=utf-8"))
+	assert.Equal(t, FormatPDF, DetectFormat("report.PDF", "application/octet-stream"))
+	assert.Equal(t, Format(""), DetectFormat("photo.jpg", "image/jpeg"))
+}
+
+func TestExtract_Markdown(t *testing.T) {
+	md := "# Release notes\n\nSee the [changelog](http://example.com) for **details**.\n\n```go\nfmt.Println()\n```\n- item one\n"
+	text, err := Extract(FormatMarkdown, strings.NewReader(md))
+	require.NoError(t, err)
+	assert.Contains(t, text, "Release notes")
+	assert.Contains(t, text, "See the changelog for details.")
+	assert.Contains(t, text, "item one")
+	assert.NotContains(t, text, "http://example.com")
+	assert.NotContains(t, text, "```")
+}
+
+func TestExtract_HTML(t *testing.T) {
+	page := `<html><head><title>Quarterly</title><style>p{color:red}</style></head>
+<body><!-- hidden --><p>Revenue &amp; growth</p><script>var x = "secret";</script></body></html>`
+	text, err := Extract(FormatHTML, strings.NewReader(page))
+	require.NoError(t, err)
+	assert.Contains(t, text, "Quarterly")
+	assert.Contains(t, text, "Revenue & growth")
+	assert.NotContains(t, text, "secret")
+	assert.NotContains(t, text, "hidden")
+	assert.NotContains(t, text, "color")
+}
+
+func pdfWithStream(content []byte, compressed bool) []byte {
+	dict := fmt.Sprintf("/Length %d", len(content))
+	if compressed {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write(content)
+		zw.Close()
+		content = buf.Bytes()
+		dict = fmt.Sprintf("/Length %d /Filter /FlateDecode", len(content))
+	}
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n1 0 obj\n<< " + dict + " >>\nstream\n")
+	b.Write(content)
+	b.WriteString("\nendstream\nendobj\n%%EOF\n")
+	return b.Bytes()
+}
+
+func TestExtract_PDF(t *testing.T) {
+	content := []byte("BT /F1 12 Tf 72 712 Td (Invoice \\(draft\\)) Tj 0 -14 Td [(Tot) -20 (al due)] TJ ET")
+
+	for _, compressed := range []bool{false, true} {
+		text, err := Extract(FormatPDF, bytes.NewReader(pdfWithStream(content, compressed)))
+		require.NoError(t, err)
+		assert.Contains(t, text, "Invoice (draft)")
+		assert.Contains(t, text, "Total due")
+	}
+
+	_, err := Extract(FormatPDF, strings.NewReader("not a pdf"))
+	assert.Error(t, err)
+}
+
+// TestExtract_PDFInflateBound checks the compressed streams of a document
+// inflate to at most MaxDocumentSize between them
+func TestExtract_PDFInflateBound(t *testing.T) {
+	padding := bytes.Repeat([]byte{' '}, MaxDocumentSize*5/8)
+	var doc []byte
+	for _, text := range []string{"first", "second", "third"} {
+		content := append(bytes.Clone(padding), "BT ("+text+") Tj ET"...)
+		doc = append(doc, pdfWithStream(content, true)...)
+	}
+	text, err := Extract(FormatPDF, bytes.NewReader(doc))
+	require.NoError(t, err)
+	assert.Contains(t, text, "first")
+	assert.NotContains(t, text, "second", "the second stream is cut at the bound")
+	assert.NotContains(t, text, "third", "nothing is inflated past the bound")
+}
+
+func TestExtract_Unsupported(t *testing.T) {
+	_, err := Extract("", strings.NewReader("x"))
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+}
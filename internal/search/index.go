@@ -0,0 +1,653 @@
+package search
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// ErrRebuildInProgress is returned when a rebuild is requested while one runs
+var ErrRebuildInProgress = errors.New("search: index rebuild already in progress")
+
+const (
+	// BM25 parameters
+	bm25K1 = 1.2
+	bm25B  = 0.75
+
+	defaultLimit        = 20
+	maxLimit            = 100
+	defaultFragmentSize = 160
+	maxFragments        = 3
+)
+
+var stopWords = map[string]struct{}{
+	"a": {}, "an": {}, "and": {}, "are": {}, "as": {}, "at": {}, "be": {}, "by": {},
+	"for": {}, "from": {}, "in": {}, "is": {}, "it": {}, "of": {}, "on": {}, "or": {},
+	"that": {}, "the": {}, "this": {}, "to": {}, "was": {}, "with": {},
+}
+
+// Document is a unit of indexed text
+type Document struct {
+	Key         string
+	Name        string
+	ContentType string
+	Text        string
+	IndexedAt   time.Time
+}
+
+// Source enumerates the documents of a store for full re-extraction
+type Source interface {
+	Documents(ctx context.Context, fn func(key, name, contentType string, r io.Reader) error) error
+}
+
+// Options configures an Index
+type Options struct {
+	// Path persists the indexed documents; empty keeps the index in memory
+	Path string
+	// FlushInterval is how often a dirty index is written to Path (default 5s)
+	FlushInterval time.Duration
+	// Source, if set, is used by Rebuild to re-extract every document
+	Source Source
+	// HighlightPre and HighlightPost wrap matched terms (default <mark>, </mark>)
+	HighlightPre  string
+	HighlightPost string
+}
+
+// Query describes a search
+type Query struct {
+	Text      string
+	Limit     int
+	Offset    int
+	Highlight bool
+}
+
+// Result is a ranked match
+type Result struct {
+	Key         string   `json:"key"`
+	Name        string   `json:"name"`
+	ContentType string   `json:"content_type,omitempty"`
+	Score       float64  `json:"score"`
+	Highlights  []string `json:"highlights,omitempty"`
+}
+
+// Results is a page of ranked matches
+type Results struct {
+	Query   string        `json:"query"`
+	Total   int           `json:"total"`
+	Results []Result      `json:"results"`
+	Took    time.Duration `json:"took"`
+}
+
+// Stats describes the index
+type Stats struct {
+	Documents   int       `json:"documents"`
+	Terms       int       `json:"terms"`
+	Rebuilding  bool      `json:"rebuilding"`
+	LastRebuild time.Time `json:"last_rebuild,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+	Persistent  bool      `json:"persistent"`
+}
+
+type journalOp struct {
+	doc    Document
+	remove bool
+}
+
+type docEntry struct {
+	doc    Document
+	length int
+}
+
+// Index is a local inverted index over extracted document text. Documents
+// keep their extracted text, which is used for highlighting and allows the
+// index to be rebuilt without access to the original files.
+type Index struct {
+	opts Options
+
+	mu       sync.RWMutex
+	docs     map[string]*docEntry
+	postings map[string]map[string]int // term -> key -> term frequency
+	totalLen int
+	dirty    bool
+	// journal records changes made while a rebuild is running so they can
+	// be replayed onto the rebuilt index
+	journal []journalOp
+
+	rebuildMu   sync.Mutex
+	rebuilding  bool
+	lastRebuild time.Time
+	lastErr     string
+
+	quitch chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewIndex creates an index, loading persisted documents from opts.Path
+func NewIndex(opts Options) (*Index, error) {
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+	if opts.HighlightPre == "" && opts.HighlightPost == "" {
+		opts.HighlightPre, opts.HighlightPost = "<mark>", "</mark>"
+	}
+
+	idx := &Index{
+		opts:     opts,
+		docs:     make(map[string]*docEntry),
+		postings: make(map[string]map[string]int),
+		quitch:   make(chan struct{}),
+	}
+
+	if opts.Path != "" {
+		if err := idx.load(); err != nil {
+			return nil, err
+		}
+		idx.wg.Add(1)
+		go idx.flushLoop()
+	}
+	return idx, nil
+}
+
+// Close flushes the index and stops background work
+func (idx *Index) Close() error {
+	select {
+	case <-idx.quitch:
+		return nil
+	default:
+		close(idx.quitch)
+	}
+	idx.wg.Wait()
+	return idx.Flush()
+}
+
+// IndexContent extracts the text of a document and indexes it
+func (idx *Index) IndexContent(key, name, contentType string, r io.Reader) error {
+	format := DetectFormat(name, contentType)
+	if format == "" {
+		return ErrUnsupportedFormat
+	}
+	text, err := Extract(format, r)
+	if err != nil {
+		return fmt.Errorf("extract %s: %w", name, err)
+	}
+	idx.Add(Document{Key: key, Name: name, ContentType: contentType, Text: text})
+	return nil
+}
+
+// Add indexes a document, replacing any previous version with the same key
+func (idx *Index) Add(doc Document) {
+	if doc.IndexedAt.IsZero() {
+		doc.IndexedAt = time.Now()
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.addLocked(doc)
+	idx.dirty = true
+	if idx.journal != nil {
+		idx.journal = append(idx.journal, journalOp{doc: doc})
+	}
+}
+
+func (idx *Index) addLocked(doc Document) {
+	idx.removeLocked(doc.Key)
+
+	// The name is indexed with the body so files can be found by title
+	terms := tokenize(doc.Name + "\n" + doc.Text)
+	freqs := make(map[string]int)
+	for _, t := range terms {
+		freqs[t.term]++
+	}
+	for term, tf := range freqs {
+		p, ok := idx.postings[term]
+		if !ok {
+			p = make(map[string]int)
+			idx.postings[term] = p
+		}
+		p[doc.Key] = tf
+	}
+	idx.docs[doc.Key] = &docEntry{doc: doc, length: len(terms)}
+	idx.totalLen += len(terms)
+}
+
+// Remove drops a document from the index
+func (idx *Index) Remove(key string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.removeLocked(key) {
+		idx.dirty = true
+	}
+	if idx.journal != nil {
+		idx.journal = append(idx.journal, journalOp{doc: Document{Key: key}, remove: true})
+	}
+}
+
+func (idx *Index) removeLocked(key string) bool {
+	entry, ok := idx.docs[key]
+	if !ok {
+		return false
+	}
+	for _, t := range tokenize(entry.doc.Name + "\n" + entry.doc.Text) {
+		if p, ok := idx.postings[t.term]; ok {
+			delete(p, key)
+			if len(p) == 0 {
+				delete(idx.postings, t.term)
+			}
+		}
+	}
+	idx.totalLen -= entry.length
+	delete(idx.docs, key)
+	return true
+}
+
+// Search ranks documents against the query with BM25. Terms ending in '*'
+// match by prefix; terms starting with '-' exclude documents containing them.
+func (idx *Index) Search(q Query) Results {
+	start := time.Now()
+	if q.Limit <= 0 {
+		q.Limit = defaultLimit
+	}
+	q.Limit = min(q.Limit, maxLimit)
+	q.Offset = max(q.Offset, 0)
+
+	include, exclude, prefixes := parseQuery(q.Text)
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	terms := append([]string(nil), include...)
+	for _, prefix := range prefixes {
+		for term := range idx.postings {
+			if strings.HasPrefix(term, prefix) {
+				terms = append(terms, term)
+			}
+		}
+	}
+
+	scores := make(map[string]float64)
+	n := float64(len(idx.docs))
+	avgLen := 1.0
+	if len(idx.docs) > 0 {
+		avgLen = float64(idx.totalLen) / n
+	}
+	for _, term := range terms {
+		p := idx.postings[term]
+		if len(p) == 0 {
+			continue
+		}
+		df := float64(len(p))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for key, tf := range p {
+			dl := float64(idx.docs[key].length)
+			f := float64(tf)
+			scores[key] += idf * f * (bm25K1 + 1) / (f + bm25K1*(1-bm25B+bm25B*dl/avgLen))
+		}
+	}
+	for _, term := range exclude {
+		for key := range idx.postings[term] {
+			delete(scores, key)
+		}
+	}
+
+	results := make([]Result, 0, len(scores))
+	for key, score := range scores {
+		doc := idx.docs[key].doc
+		results = append(results, Result{Key: key, Name: doc.Name, ContentType: doc.ContentType, Score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Key < results[j].Key
+	})
+
+	total := len(results)
+	if q.Offset >= len(results) {
+		results = results[:0]
+	} else {
+		results = results[q.Offset:min(q.Offset+q.Limit, len(results))]
+	}
+
+	if q.Highlight {
+		match := make(map[string]struct{}, len(terms))
+		for _, t := range terms {
+			match[t] = struct{}{}
+		}
+		for i := range results {
+			results[i].Highlights = idx.highlight(idx.docs[results[i].Key].doc.Text, match)
+		}
+	}
+
+	return Results{Query: q.Text, Total: total, Results: results, Took: time.Since(start)}
+}
+
+// highlight returns up to maxFragments snippets around matched terms
+func (idx *Index) highlight(text string, match map[string]struct{}) []string {
+	var hits []token
+	for _, t := range tokenize(text) {
+		if _, ok := match[t.term]; ok {
+			hits = append(hits, t)
+		}
+	}
+
+	var fragments []string
+	covered := -1
+	for i, hit := range hits {
+		if hit.start < covered {
+			continue
+		}
+		from := snapLeft(text, hit.start-defaultFragmentSize/2)
+		to := snapRight(text, hit.end+defaultFragmentSize/2)
+		covered = to
+
+		var b strings.Builder
+		if from > 0 {
+			b.WriteString("…")
+		}
+		pos := from
+		for _, h := range hits[i:] {
+			if h.start >= to {
+				break
+			}
+			b.WriteString(text[pos:h.start])
+			b.WriteString(idx.opts.HighlightPre)
+			b.WriteString(text[h.start:h.end])
+			b.WriteString(idx.opts.HighlightPost)
+			pos = h.end
+		}
+		b.WriteString(text[pos:to])
+		if to < len(text) {
+			b.WriteString("…")
+		}
+		fragments = append(fragments, strings.Join(strings.Fields(b.String()), " "))
+		if len(fragments) == maxFragments {
+			break
+		}
+	}
+	return fragments
+}
+
+func snapLeft(text string, i int) int {
+	if i <= 0 {
+		return 0
+	}
+	if j := strings.LastIndexAny(text[:i], " \n\t"); j >= 0 {
+		return j + 1
+	}
+	return 0
+}
+
+func snapRight(text string, i int) int {
+	if i >= len(text) {
+		return len(text)
+	}
+	if j := strings.IndexAny(text[i:], " \n\t"); j >= 0 {
+		return i + j
+	}
+	return len(text)
+}
+
+// Get returns an indexed document
+func (idx *Index) Get(key string) (Document, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	entry, ok := idx.docs[key]
+	if !ok {
+		return Document{}, false
+	}
+	return entry.doc, true
+}
+
+// Stats returns index statistics
+func (idx *Index) Stats() Stats {
+	idx.mu.RLock()
+	docs, terms := len(idx.docs), len(idx.postings)
+	idx.mu.RUnlock()
+
+	idx.rebuildMu.Lock()
+	defer idx.rebuildMu.Unlock()
+	return Stats{
+		Documents:   docs,
+		Terms:       terms,
+		Rebuilding:  idx.rebuilding,
+		LastRebuild: idx.lastRebuild,
+		LastError:   idx.lastErr,
+		Persistent:  idx.opts.Path != "",
+	}
+}
+
+// Rebuild recreates the index. With a Source every document is extracted
+// again; otherwise the stored text is re-tokenized. Searches keep being
+// served from the old index until the new one is swapped in.
+func (idx *Index) Rebuild(ctx context.Context) error {
+	idx.rebuildMu.Lock()
+	if idx.rebuilding {
+		idx.rebuildMu.Unlock()
+		return ErrRebuildInProgress
+	}
+	idx.rebuilding = true
+	idx.rebuildMu.Unlock()
+
+	err := idx.rebuild(ctx)
+
+	idx.rebuildMu.Lock()
+	defer idx.rebuildMu.Unlock()
+	idx.rebuilding = false
+	idx.lastRebuild = time.Now()
+	idx.lastErr = ""
+	if err != nil {
+		idx.lastErr = err.Error()
+	}
+	return err
+}
+
+func (idx *Index) rebuild(ctx context.Context) error {
+	next := &Index{
+		opts:     idx.opts,
+		docs:     make(map[string]*docEntry),
+		postings: make(map[string]map[string]int),
+	}
+
+	idx.mu.Lock()
+	idx.journal = []journalOp{}
+	idx.mu.Unlock()
+	defer func() {
+		idx.mu.Lock()
+		idx.journal = nil
+		idx.mu.Unlock()
+	}()
+
+	if idx.opts.Source != nil {
+		err := idx.opts.Source.Documents(ctx, func(key, name, contentType string, r io.Reader) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			format := DetectFormat(name, contentType)
+			if format == "" {
+				return nil
+			}
+			text, err := Extract(format, r)
+			if err != nil {
+				slog.Warn("search: skipping document", "key", key, "error", err)
+				return nil
+			}
+			next.addLocked(Document{Key: key, Name: name, ContentType: contentType, Text: text, IndexedAt: time.Now()})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		idx.mu.RLock()
+		docs := make([]Document, 0, len(idx.docs))
+		for _, entry := range idx.docs {
+			docs = append(docs, entry.doc)
+		}
+		idx.mu.RUnlock()
+		for _, doc := range docs {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			next.addLocked(doc)
+		}
+	}
+
+	idx.mu.Lock()
+	for _, op := range idx.journal {
+		if op.remove {
+			next.removeLocked(op.doc.Key)
+		} else {
+			next.addLocked(op.doc)
+		}
+	}
+	idx.docs, idx.postings, idx.totalLen = next.docs, next.postings, next.totalLen
+	idx.dirty = true
+	idx.mu.Unlock()
+	return idx.Flush()
+}
+
+// Flush writes the index to disk if it is persistent and has changed
+func (idx *Index) Flush() error {
+	if idx.opts.Path == "" {
+		return nil
+	}
+
+	idx.mu.Lock()
+	if !idx.dirty {
+		idx.mu.Unlock()
+		return nil
+	}
+	docs := make([]Document, 0, len(idx.docs))
+	for _, entry := range idx.docs {
+		docs = append(docs, entry.doc)
+	}
+	idx.dirty = false
+	idx.mu.Unlock()
+
+	if err := idx.save(docs); err != nil {
+		idx.mu.Lock()
+		idx.dirty = true
+		idx.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (idx *Index) save(docs []Document) error {
+	if err := os.MkdirAll(filepath.Dir(idx.opts.Path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := idx.opts.Path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(docs); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, idx.opts.Path)
+}
+
+func (idx *Index) load() error {
+	f, err := os.Open(idx.opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var docs []Document
+	if err := gob.NewDecoder(f).Decode(&docs); err != nil {
+		return fmt.Errorf("search: corrupt index %s: %w", idx.opts.Path, err)
+	}
+	for _, doc := range docs {
+		idx.addLocked(doc)
+	}
+	return nil
+}
+
+func (idx *Index) flushLoop() {
+	defer idx.wg.Done()
+	ticker := time.NewTicker(idx.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-idx.quitch:
+			return
+		case <-ticker.C:
+			if err := idx.Flush(); err != nil {
+				slog.Error("search: failed to flush index", "error", err)
+			}
+		}
+	}
+}
+
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into lower-cased terms with their byte offsets,
+// dropping stop words
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	emit := func(end int) {
+		term := strings.ToLower(text[start:end])
+		if _, stop := stopWords[term]; !stop {
+			tokens = append(tokens, token{term: term, start: start, end: end})
+		}
+		start = -1
+	}
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+		} else if start >= 0 {
+			emit(i)
+		}
+	}
+	if start >= 0 {
+		emit(len(text))
+	}
+	return tokens
+}
+
+// parseQuery splits a query into scored terms, excluded terms and prefixes
+func parseQuery(q string) (include, exclude, prefixes []string) {
+	for _, field := range strings.Fields(q) {
+		switch {
+		case strings.HasPrefix(field, "-") && len(field) > 1:
+			for _, t := range tokenize(field[1:]) {
+				exclude = append(exclude, t.term)
+			}
+		case strings.HasSuffix(field, "*") && len(field) > 1:
+			for _, t := range tokenize(strings.TrimSuffix(field, "*")) {
+				prefixes = append(prefixes, t.term)
+			}
+		default:
+			for _, t := range tokenize(field) {
+				include = append(include, t.term)
+			}
+		}
+	}
+	return include, exclude, prefixes
+}
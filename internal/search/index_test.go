@@ -0,0 +1,147 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestIndex(t *testing.T, opts Options) *Index {
+	t.Helper()
+	idx, err := NewIndex(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = idx.Close() })
+	return idx
+}
+
+func keys(results Results) []string {
+	var out []string
+	for _, r := range results.Results {
+		out = append(out, r.Key)
+	}
+	return out
+}
+
+func TestIndex_Ranking(t *testing.T) {
+	idx := newTestIndex(t, Options{})
+	idx.Add(Document{Key: "a", Name: "a.txt", Text: "replication lag"})
+	idx.Add(Document{Key: "b", Name: "b.txt", Text: "replication of metadata with a long tail of unrelated words about storage nodes"})
+	idx.Add(Document{Key: "c", Name: "budget.txt", Text: "storage quotas"})
+
+	res := idx.Search(Query{Text: "replication"})
+	assert.Equal(t, 2, res.Total)
+	assert.Equal(t, []string{"a", "b"}, keys(res))
+	assert.Greater(t, res.Results[0].Score, res.Results[1].Score)
+
+	// Documents matching more terms rank higher
+	res = idx.Search(Query{Text: "storage replication"})
+	assert.Equal(t, "b", res.Results[0].Key)
+
+	// Exclusion and prefix queries
+	assert.Equal(t, []string{"a"}, keys(idx.Search(Query{Text: "replication -metadata"})))
+	assert.Equal(t, []string{"a", "b"}, keys(idx.Search(Query{Text: "replic*"})))
+
+	// Names are searchable
+	assert.Equal(t, []string{"c"}, keys(idx.Search(Query{Text: "budget"})))
+
+	// Paging
+	res = idx.Search(Query{Text: "replication", Limit: 1, Offset: 1})
+	assert.Equal(t, 2, res.Total)
+	assert.Equal(t, []string{"b"}, keys(res))
+}
+
+func TestIndex_Highlight(t *testing.T) {
+	idx := newTestIndex(t, Options{HighlightPre: "[", HighlightPost: "]"})
+	text := strings.Repeat("filler ", 60) + "the Quarterly report shows growth " + strings.Repeat("padding ", 60)
+	idx.Add(Document{Key: "doc", Name: "doc.txt", Text: text})
+
+	res := idx.Search(Query{Text: "quarterly growth", Highlight: true})
+	require.Len(t, res.Results, 1)
+	require.Len(t, res.Results[0].Highlights, 1)
+	fragment := res.Results[0].Highlights[0]
+	assert.Contains(t, fragment, "[Quarterly] report shows [growth]")
+	assert.True(t, strings.HasPrefix(fragment, "…"))
+	assert.True(t, strings.HasSuffix(fragment, "…"))
+
+	res = idx.Search(Query{Text: "quarterly"})
+	assert.Empty(t, res.Results[0].Highlights)
+}
+
+func TestIndex_ReplaceAndRemove(t *testing.T) {
+	idx := newTestIndex(t, Options{})
+	idx.Add(Document{Key: "k", Name: "k.txt", Text: "alpha"})
+	idx.Add(Document{Key: "k", Name: "k.txt", Text: "beta"})
+
+	assert.Zero(t, idx.Search(Query{Text: "alpha"}).Total)
+	assert.Equal(t, 1, idx.Search(Query{Text: "beta"}).Total)
+
+	idx.Remove("k")
+	assert.Zero(t, idx.Search(Query{Text: "beta"}).Total)
+	assert.Zero(t, idx.Stats().Documents)
+	assert.Zero(t, idx.Stats().Terms)
+}
+
+func TestIndex_IndexContent(t *testing.T) {
+	idx := newTestIndex(t, Options{})
+	require.NoError(t, idx.IndexContent("p", "page.html", "text/html", strings.NewReader("<p>Hello <b>world</b></p>")))
+	assert.ErrorIs(t, idx.IndexContent("i", "photo.png", "image/png", strings.NewReader("")), ErrUnsupportedFormat)
+
+	doc, ok := idx.Get("p")
+	require.True(t, ok)
+	assert.Equal(t, "Hello world", doc.Text)
+}
+
+func TestIndex_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search", "index.gob")
+	idx, err := NewIndex(Options{Path: path})
+	require.NoError(t, err)
+	idx.Add(Document{Key: "k", Name: "k.md", Text: "persisted words"})
+	require.NoError(t, idx.Close())
+
+	reopened := newTestIndex(t, Options{Path: path})
+	assert.True(t, reopened.Stats().Persistent)
+	assert.Equal(t, []string{"k"}, keys(reopened.Search(Query{Text: "persisted"})))
+}
+
+type mapSource map[string]string
+
+func (s mapSource) Documents(ctx context.Context, fn func(key, name, contentType string, r io.Reader) error) error {
+	for key, body := range s {
+		if err := fn(key, key, "", bytes.NewReader([]byte(body))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestIndex_Rebuild(t *testing.T) {
+	source := mapSource{"one.txt": "fresh content", "two.bin": "binary"}
+	idx := newTestIndex(t, Options{Source: source})
+	idx.Add(Document{Key: "stale.txt", Name: "stale.txt", Text: "stale content"})
+
+	require.NoError(t, idx.Rebuild(context.Background()))
+	stats := idx.Stats()
+	assert.Equal(t, 1, stats.Documents)
+	assert.False(t, stats.LastRebuild.IsZero())
+	assert.Equal(t, []string{"one.txt"}, keys(idx.Search(Query{Text: "content"})))
+
+	// Without a source the stored text is re-tokenized
+	plain := newTestIndex(t, Options{})
+	plain.Add(Document{Key: "k", Name: "k.txt", Text: "kept"})
+	require.NoError(t, plain.Rebuild(context.Background()))
+	assert.Equal(t, []string{"k"}, keys(plain.Search(Query{Text: "kept"})))
+}
+
+func TestTokenize(t *testing.T) {
+	var terms []string
+	for _, tok := range tokenize("The Café's API-v2, and 42 things") {
+		terms = append(terms, tok.term)
+	}
+	assert.Equal(t, []string{"café", "s", "api", "v2", "42", "things"}, terms)
+}
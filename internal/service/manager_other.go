@@ -0,0 +1,17 @@
+//go:build !windows
+
+package service
+
+import "runtime"
+
+// New returns the service manager of the running platform
+func New() (Manager, error) {
+	if runtime.GOOS != "linux" {
+		return nil, ErrUnsupported
+	}
+	return NewSystemd(), nil
+}
+
+func runService(name string, fn func(stop <-chan struct{}) error) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package service
+
+// New returns the service manager of the running platform
+func New() (Manager, error) {
+	return NewWindows(), nil
+}
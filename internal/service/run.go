@@ -0,0 +1,34 @@
+package service
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Run runs fn until the process is asked to stop, then closes stop and
+// waits for fn to return. Under the Windows Service Control Manager stop
+// and shutdown requests end it; otherwise SIGINT and SIGTERM do, which is
+// how systemd stops a unit. An error from fn is returned so the process
+// can exit non-zero and be restarted.
+func Run(name string, fn func(stop <-chan struct{}) error) error {
+	if handled, err := runService(name, fn); handled {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- fn(stop) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-signals:
+		close(stop)
+		return <-done
+	}
+}
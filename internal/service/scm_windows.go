@@ -0,0 +1,210 @@
+//go:build windows
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// Windows manages services through the Service Control Manager. Services
+// have no working directory or output redirection of their own, so the
+// binary has to change directory and open its log file itself.
+type Windows struct{}
+
+// NewWindows creates a Service Control Manager client
+func NewWindows() *Windows {
+	return &Windows{}
+}
+
+// Install registers the service to start automatically, restarting it
+// after failures including non-zero exits
+func (w *Windows) Install(cfg Config) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(cfg.Name); err == nil {
+		s.Close()
+		return fmt.Errorf("%w: %s", ErrAlreadyInstalled, cfg.Name)
+	}
+	s, err := m.CreateService(cfg.Name, cfg.Executable, mgr.Config{
+		DisplayName: cfg.DisplayName,
+		Description: cfg.Description,
+		StartType:   mgr.StartAutomatic,
+	}, cfg.Args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	restart := mgr.RecoveryAction{Type: mgr.ServiceRestart, Delay: cfg.RestartDelay}
+	// Failure counts reset after a day without failures
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{restart, restart, restart}, 24*60*60); err != nil {
+		s.Delete()
+		return err
+	}
+	if err := s.SetRecoveryActionsOnNonCrashFailures(true); err != nil {
+		s.Delete()
+		return err
+	}
+	return nil
+}
+
+// Uninstall stops the service and removes it
+func (w *Windows) Uninstall(name string) error {
+	return w.with(name, func(s *mgr.Service) error {
+		if err := stopService(s); err != nil {
+			return err
+		}
+		return s.Delete()
+	})
+}
+
+// Start starts the service
+func (w *Windows) Start(name string) error {
+	return w.with(name, func(s *mgr.Service) error {
+		return s.Start()
+	})
+}
+
+// Stop asks the service to stop and waits for it
+func (w *Windows) Stop(name string) error {
+	return w.with(name, stopService)
+}
+
+// Status reports the service state
+func (w *Windows) Status(name string) (Status, error) {
+	status := StatusUnknown
+	err := w.with(name, func(s *mgr.Service) error {
+		st, err := s.Query()
+		if err != nil {
+			return err
+		}
+		switch st.State {
+		case svc.Running:
+			status = StatusRunning
+		case svc.Stopped:
+			status = StatusStopped
+			if st.Win32ExitCode != 0 || st.ServiceSpecificExitCode != 0 {
+				status = StatusFailed
+			}
+		case svc.StartPending, svc.ContinuePending:
+			status = StatusStarting
+		case svc.StopPending, svc.PausePending, svc.Paused:
+			status = StatusStopping
+		}
+		return nil
+	})
+	if errors.Is(err, ErrNotInstalled) {
+		return StatusNotInstalled, nil
+	}
+	return status, err
+}
+
+func (w *Windows) with(name string, fn func(*mgr.Service) error) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST) {
+		return fmt.Errorf("%w: %s", ErrNotInstalled, name)
+	}
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return fn(s)
+}
+
+// stopService sends a stop request and waits up to 30 seconds, the same
+// grace period the systemd unit gets
+func stopService(s *mgr.Service) error {
+	st, err := s.Query()
+	if err != nil {
+		return err
+	}
+	if st.State == svc.Stopped {
+		return nil
+	}
+	if st.State != svc.StopPending {
+		if st, err = s.Control(svc.Stop); err != nil {
+			return err
+		}
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for st.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service: %s did not stop within 30s", s.Name)
+		}
+		time.Sleep(300 * time.Millisecond)
+		if st, err = s.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runService runs fn under the Service Control Manager when the process
+// was started by it
+func runService(name string, fn func(stop <-chan struct{}) error) (bool, error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false, err
+	}
+	h := &handler{fn: fn}
+	if err := svc.Run(name, h); err != nil {
+		return true, err
+	}
+	return true, h.err
+}
+
+type handler struct {
+	fn  func(stop <-chan struct{}) error
+	err error
+}
+
+func (h *handler) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- h.fn(stop) }()
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case err := <-done:
+			// Exiting on its own is a failure the recovery actions act on
+			h.err = err
+			return true, 1
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				changes <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				close(stop)
+				h.err = <-done
+				if h.err != nil {
+					return true, 1
+				}
+				return false, 0
+			}
+		}
+	}
+}
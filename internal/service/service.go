@@ -0,0 +1,102 @@
+// Package service registers PeerVault binaries with the operating system's
+// service manager, a systemd unit on Linux and a Windows service on Windows,
+// and runs them under it with graceful stops, log redirection and automatic
+// restarts.
+package service
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+var (
+	// ErrUnsupported is returned on platforms without a supported service manager
+	ErrUnsupported = errors.New("service: not supported on this platform")
+	// ErrNotInstalled is returned for a service that isn't registered
+	ErrNotInstalled = errors.New("service: not installed")
+	// ErrAlreadyInstalled is returned when installing over an existing service
+	ErrAlreadyInstalled = errors.New("service: already installed")
+)
+
+// DefaultRestartDelay is how long the service manager waits before
+// restarting a service that failed
+const DefaultRestartDelay = 5 * time.Second
+
+// Config describes a service to install
+type Config struct {
+	// Name identifies the unit or service, e.g. "peervault-node"
+	Name        string
+	DisplayName string
+	Description string
+	// Executable is the absolute path of the binary and Args its arguments
+	Executable string
+	Args       []string
+	// WorkingDir is where relative storage paths resolve
+	WorkingDir string
+	// User runs the service as an unprivileged account (systemd only)
+	User string
+	// LogFile receives the service's output. Empty leaves it to the
+	// journal on Linux; Windows services have no console, so a log file
+	// should always be set there.
+	LogFile string
+	// RestartDelay is the wait before restarting after a failure
+	RestartDelay time.Duration
+}
+
+// Status is the state of an installed service
+type Status string
+
+const (
+	StatusRunning      Status = "running"
+	StatusStopped      Status = "stopped"
+	StatusStarting     Status = "starting"
+	StatusStopping     Status = "stopping"
+	StatusFailed       Status = "failed"
+	StatusNotInstalled Status = "not-installed"
+	StatusUnknown      Status = "unknown"
+)
+
+// Manager installs and controls services
+type Manager interface {
+	Install(cfg Config) error
+	Uninstall(name string) error
+	Start(name string) error
+	Stop(name string) error
+	Status(name string) (Status, error)
+}
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.@-]*$`)
+
+// ValidateName checks that name is usable as a unit or service name
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("service: invalid name %q", name)
+	}
+	return nil
+}
+
+// validate checks the config and fills in defaults
+func (c *Config) validate() error {
+	if err := ValidateName(c.Name); err != nil {
+		return err
+	}
+	if !filepath.IsAbs(c.Executable) {
+		return fmt.Errorf("service: executable %q must be an absolute path", c.Executable)
+	}
+	if c.WorkingDir != "" && !filepath.IsAbs(c.WorkingDir) {
+		return fmt.Errorf("service: working directory %q must be an absolute path", c.WorkingDir)
+	}
+	if c.LogFile != "" && !filepath.IsAbs(c.LogFile) {
+		return fmt.Errorf("service: log file %q must be an absolute path", c.LogFile)
+	}
+	if c.DisplayName == "" {
+		c.DisplayName = c.Name
+	}
+	if c.RestartDelay <= 0 {
+		c.RestartDelay = DefaultRestartDelay
+	}
+	return nil
+}
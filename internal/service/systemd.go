@@ -0,0 +1,222 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultUnitDir is where systemd looks for administrator-installed units
+const DefaultUnitDir = "/etc/systemd/system"
+
+// Systemd manages services as systemd units
+type Systemd struct {
+	// UnitDir is where unit files are written; defaults to DefaultUnitDir
+	UnitDir string
+	// run invokes systemctl; replaced in tests
+	run func(args ...string) ([]byte, error)
+}
+
+// NewSystemd creates a systemd manager
+func NewSystemd() *Systemd {
+	return &Systemd{UnitDir: DefaultUnitDir, run: systemctl}
+}
+
+func systemctl(args ...string) ([]byte, error) {
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("systemctl %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+func (s *Systemd) unitPath(name string) string {
+	return filepath.Join(s.UnitDir, name+".service")
+}
+
+// Install writes the unit file and enables it so it starts at boot
+func (s *Systemd) Install(cfg Config) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	path := s.unitPath(cfg.Name)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%w: %s", ErrAlreadyInstalled, path)
+	}
+	if cfg.LogFile != "" {
+		if err := os.MkdirAll(filepath.Dir(cfg.LogFile), 0755); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(path, []byte(Unit(cfg)), 0644); err != nil {
+		return err
+	}
+	if _, err := s.run("daemon-reload"); err != nil {
+		os.Remove(path)
+		return err
+	}
+	if _, err := s.run("enable", cfg.Name+".service"); err != nil {
+		os.Remove(path)
+		s.run("daemon-reload")
+		return err
+	}
+	return nil
+}
+
+// Uninstall stops and disables the unit and removes its file
+func (s *Systemd) Uninstall(name string) error {
+	if err := s.installed(name); err != nil {
+		return err
+	}
+	// Stopping an already stopped unit succeeds, so a failure here is real
+	if _, err := s.run("stop", name+".service"); err != nil {
+		return err
+	}
+	if _, err := s.run("disable", name+".service"); err != nil {
+		return err
+	}
+	if err := os.Remove(s.unitPath(name)); err != nil {
+		return err
+	}
+	_, err := s.run("daemon-reload")
+	return err
+}
+
+// Start starts the unit
+func (s *Systemd) Start(name string) error {
+	if err := s.installed(name); err != nil {
+		return err
+	}
+	_, err := s.run("start", name+".service")
+	return err
+}
+
+// Stop stops the unit; systemd sends SIGTERM and waits for a clean exit
+func (s *Systemd) Stop(name string) error {
+	if err := s.installed(name); err != nil {
+		return err
+	}
+	_, err := s.run("stop", name+".service")
+	return err
+}
+
+// Status reports the unit's active state
+func (s *Systemd) Status(name string) (Status, error) {
+	if err := s.installed(name); err != nil {
+		if errors.Is(err, ErrNotInstalled) {
+			return StatusNotInstalled, nil
+		}
+		return StatusUnknown, err
+	}
+	// is-active exits non-zero for anything but "active" and still prints
+	// the state, so the error is ignored when there is output
+	out, err := s.run("is-active", name+".service")
+	state := strings.TrimSpace(firstLine(string(out)))
+	switch state {
+	case "active", "reloading":
+		return StatusRunning, nil
+	case "inactive":
+		return StatusStopped, nil
+	case "activating":
+		return StatusStarting, nil
+	case "deactivating":
+		return StatusStopping, nil
+	case "failed":
+		return StatusFailed, nil
+	}
+	if err != nil {
+		return StatusUnknown, err
+	}
+	return StatusUnknown, nil
+}
+
+func (s *Systemd) installed(name string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	_, err := os.Stat(s.unitPath(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrNotInstalled, name)
+	}
+	return err
+}
+
+// Unit renders the systemd unit for cfg. The service restarts on failure
+// but not after a clean exit, and gets SIGTERM and 30 seconds to stop.
+func Unit(cfg Config) string {
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=%s\n", unitValue(orDefault(cfg.Description, cfg.DisplayName)))
+	b.WriteString("Wants=network-online.target\n")
+	b.WriteString("After=network-online.target\n")
+	// Keep restarting however often it fails; RestartSec paces it
+	b.WriteString("StartLimitIntervalSec=0\n")
+	b.WriteString("\n[Service]\n")
+	b.WriteString("Type=simple\n")
+	fmt.Fprintf(&b, "ExecStart=%s\n", execLine(cfg.Executable, cfg.Args))
+	if cfg.WorkingDir != "" {
+		fmt.Fprintf(&b, "WorkingDirectory=%s\n", unitValue(cfg.WorkingDir))
+	}
+	if cfg.User != "" {
+		fmt.Fprintf(&b, "User=%s\n", unitValue(cfg.User))
+	}
+	b.WriteString("Restart=on-failure\n")
+	delay := cfg.RestartDelay
+	if delay <= 0 {
+		delay = DefaultRestartDelay
+	}
+	fmt.Fprintf(&b, "RestartSec=%d\n", max(1, int(delay.Seconds())))
+	b.WriteString("KillSignal=SIGTERM\n")
+	b.WriteString("TimeoutStopSec=30\n")
+	if cfg.LogFile != "" {
+		fmt.Fprintf(&b, "StandardOutput=append:%s\n", unitValue(cfg.LogFile))
+		fmt.Fprintf(&b, "StandardError=append:%s\n", unitValue(cfg.LogFile))
+	}
+	b.WriteString("LimitNOFILE=65536\n")
+	b.WriteString("\n[Install]\n")
+	b.WriteString("WantedBy=multi-user.target\n")
+	return b.String()
+}
+
+// execLine quotes the command line the way systemd parses ExecStart
+func execLine(exe string, args []string) string {
+	parts := make([]string, 0, len(args)+1)
+	for _, arg := range append([]string{exe}, args...) {
+		parts = append(parts, quoteArg(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+func quoteArg(arg string) string {
+	// "%" and "$" would otherwise be expanded as specifiers and variables
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	arg = strings.ReplaceAll(arg, "$", "$$")
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;") {
+		return arg
+	}
+	arg = strings.ReplaceAll(arg, `\`, `\\`)
+	arg = strings.ReplaceAll(arg, `"`, `\"`)
+	return `"` + arg + `"`
+}
+
+// unitValue escapes specifiers in a plain setting and keeps it on one line
+func unitValue(s string) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	return strings.ReplaceAll(s, "%", "%%")
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSystemctl records systemctl calls and answers is-active
+type fakeSystemctl struct {
+	calls  []string
+	active string
+}
+
+func (f *fakeSystemctl) run(args ...string) ([]byte, error) {
+	f.calls = append(f.calls, strings.Join(args, " "))
+	if args[0] == "is-active" {
+		return []byte(f.active + "\n"), nil
+	}
+	return nil, nil
+}
+
+func newTestSystemd(t *testing.T) (*Systemd, *fakeSystemctl) {
+	fake := &fakeSystemctl{active: "inactive"}
+	return &Systemd{UnitDir: t.TempDir(), run: fake.run}, fake
+}
+
+func testConfig(t *testing.T) Config {
+	dir := t.TempDir()
+	return Config{
+		Name:        "peervault-node",
+		Description: "PeerVault node",
+		Executable:  "/usr/local/bin/peervault-node",
+		Args:        []string{"run", "-listen", ":3000", "-bootstrap", "a:3000, b:3000"},
+		WorkingDir:  "/var/lib/peervault",
+		User:        "peervault",
+		LogFile:     filepath.Join(dir, "logs", "node.log"),
+	}
+}
+
+func TestUnit(t *testing.T) {
+	cfg := testConfig(t)
+	require.NoError(t, cfg.validate())
+	unit := Unit(cfg)
+
+	assert.Contains(t, unit, `ExecStart=/usr/local/bin/peervault-node run -listen :3000 -bootstrap "a:3000, b:3000"`+"\n")
+	assert.Contains(t, unit, "WorkingDirectory=/var/lib/peervault\n")
+	assert.Contains(t, unit, "User=peervault\n")
+	assert.Contains(t, unit, "Restart=on-failure\nRestartSec=5\n")
+	assert.Contains(t, unit, "StandardOutput=append:"+cfg.LogFile+"\n")
+	assert.Contains(t, unit, "StandardError=append:"+cfg.LogFile+"\n")
+	assert.Contains(t, unit, "WantedBy=multi-user.target\n")
+}
+
+func TestUnit_EscapesSpecifiers(t *testing.T) {
+	assert.Equal(t, "100%%", quoteArg("100%"))
+	assert.Equal(t, "$$HOME", quoteArg("$HOME"))
+	assert.Equal(t, `"say \"hi\""`, quoteArg(`say "hi"`))
+	assert.Equal(t, `""`, quoteArg(""))
+
+	cfg := testConfig(t)
+	cfg.LogFile = ""
+	cfg.RestartDelay = 30 * time.Second
+	require.NoError(t, cfg.validate())
+	unit := Unit(cfg)
+	assert.NotContains(t, unit, "StandardOutput")
+	assert.Contains(t, unit, "RestartSec=30\n")
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Name = "bad name"
+	assert.Error(t, cfg.validate())
+
+	cfg = testConfig(t)
+	cfg.Executable = "peervault-node"
+	assert.Error(t, cfg.validate())
+
+	cfg = testConfig(t)
+	cfg.LogFile = "node.log"
+	assert.Error(t, cfg.validate())
+}
+
+func TestSystemd_Lifecycle(t *testing.T) {
+	s, fake := newTestSystemd(t)
+	cfg := testConfig(t)
+
+	status, err := s.Status(cfg.Name)
+	require.NoError(t, err)
+	assert.Equal(t, StatusNotInstalled, status)
+	assert.ErrorIs(t, s.Start(cfg.Name), ErrNotInstalled)
+
+	require.NoError(t, s.Install(cfg))
+	data, err := os.ReadFile(filepath.Join(s.UnitDir, "peervault-node.service"))
+	require.NoError(t, err)
+	assert.Equal(t, Unit(cfg), string(data))
+	assert.DirExists(t, filepath.Dir(cfg.LogFile))
+	assert.Equal(t, []string{"daemon-reload", "enable peervault-node.service"}, fake.calls)
+	assert.ErrorIs(t, s.Install(cfg), ErrAlreadyInstalled)
+
+	require.NoError(t, s.Start(cfg.Name))
+	fake.active = "active"
+	status, err = s.Status(cfg.Name)
+	require.NoError(t, err)
+	assert.Equal(t, StatusRunning, status)
+
+	fake.active = "failed"
+	status, err = s.Status(cfg.Name)
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, status)
+
+	fake.calls = nil
+	require.NoError(t, s.Uninstall(cfg.Name))
+	assert.Equal(t, []string{"stop peervault-node.service", "disable peervault-node.service", "daemon-reload"}, fake.calls)
+	assert.NoFileExists(t, filepath.Join(s.UnitDir, "peervault-node.service"))
+}
@@ -0,0 +1,474 @@
+package sharing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/audit"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+)
+
+var (
+	ErrNotFound          = errors.New("sharing: link not found")
+	ErrInvalidSignature  = errors.New("sharing: invalid link signature")
+	ErrExpired           = errors.New("sharing: link expired")
+	ErrRevoked           = errors.New("sharing: link revoked")
+	ErrDownloadLimit     = errors.New("sharing: download limit reached")
+	ErrPasswordRequired  = errors.New("sharing: password required")
+	ErrPasswordMismatch  = errors.New("sharing: wrong password")
+	ErrInvalidExpiration = errors.New("sharing: expiry must be in the future and at most MaxTTL away")
+)
+
+const (
+	// DefaultTTL is used when a link is created without an expiry
+	DefaultTTL = 24 * time.Hour
+	// MaxTTL bounds how long a link can stay valid
+	MaxTTL = 90 * 24 * time.Hour
+
+	// PathPrefix is where the gateway serves shared links
+	PathPrefix = "/s/"
+
+	passwordIterations = 100_000
+	linkIDBytes        = 16
+)
+
+// Link is a signed, expiring grant to download a single file
+type Link struct {
+	ID           string    `json:"id"`
+	Key          string    `json:"key"`
+	CreatedBy    string    `json:"created_by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	MaxDownloads int       `json:"max_downloads,omitempty"` // 0 means unlimited
+	Downloads    int       `json:"downloads"`
+	LastAccessed time.Time `json:"last_accessed,omitempty"`
+	Revoked      bool      `json:"revoked"`
+	RevokedAt    time.Time `json:"revoked_at,omitempty"`
+
+	PasswordSalt []byte `json:"password_salt,omitempty"`
+	PasswordHash []byte `json:"password_hash,omitempty"`
+}
+
+// HasPassword reports whether the link is password protected
+func (l *Link) HasPassword() bool { return len(l.PasswordHash) > 0 }
+
+// Active reports whether the link can still be used at the given time
+func (l *Link) Active(now time.Time) bool {
+	return !l.Revoked && now.Before(l.ExpiresAt) && (l.MaxDownloads == 0 || l.Downloads < l.MaxDownloads)
+}
+
+// CreateOptions describes a new link
+type CreateOptions struct {
+	Key          string
+	TTL          time.Duration
+	MaxDownloads int
+	Password     string
+	CreatedBy    string
+}
+
+// Access describes a request to use a link
+type Access struct {
+	ID        string
+	Expires   string
+	Signature string
+	Password  string
+	IPAddress string
+	UserAgent string
+}
+
+// ManagerOpts configures a Manager
+type ManagerOpts struct {
+	// Secret is the master secret links are signed with. A purpose-bound
+	// sub-key is derived from it, so it may be shared with other subsystems.
+	Secret []byte
+	// Path persists links; empty keeps them in memory
+	Path string
+	// BaseURL prefixes generated URLs, e.g. https://vault.example.com
+	BaseURL string
+	// Audit receives access events; defaults to the global audit logger
+	Audit *audit.AuditLogger
+}
+
+// Manager issues, verifies and revokes share links
+type Manager struct {
+	opts    ManagerOpts
+	signKey []byte
+
+	mu    sync.Mutex
+	links map[string]*Link
+}
+
+// NewManager creates a link manager, loading persisted links from opts.Path
+func NewManager(opts ManagerOpts) (*Manager, error) {
+	if len(opts.Secret) == 0 {
+		return nil, errors.New("sharing: secret is required")
+	}
+	signKey, err := crypto.DeriveSubKey(opts.Secret, crypto.PurposeShareLink, "")
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{opts: opts, signKey: signKey, links: make(map[string]*Link)}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Create issues a new link and returns it together with its signed URL
+func (m *Manager) Create(opts CreateOptions) (*Link, string, error) {
+	if opts.Key == "" {
+		return nil, "", errors.New("sharing: key is required")
+	}
+	if opts.TTL == 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.TTL < 0 || opts.TTL > MaxTTL {
+		return nil, "", ErrInvalidExpiration
+	}
+	if opts.MaxDownloads < 0 {
+		return nil, "", errors.New("sharing: max downloads cannot be negative")
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	link := &Link{
+		ID:           id,
+		Key:          opts.Key,
+		CreatedBy:    opts.CreatedBy,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(opts.TTL).Truncate(time.Second),
+		MaxDownloads: opts.MaxDownloads,
+	}
+	if opts.Password != "" {
+		link.PasswordSalt = make([]byte, 16)
+		if _, err := rand.Read(link.PasswordSalt); err != nil {
+			return nil, "", err
+		}
+		link.PasswordHash, err = hashPassword(opts.Password, link.PasswordSalt)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	m.mu.Lock()
+	m.links[id] = link
+	err = m.saveLocked()
+	m.mu.Unlock()
+	if err != nil {
+		return nil, "", err
+	}
+
+	m.audit(context.Background(), link, "create", "success", Access{}, nil)
+	copied := *link
+	return &copied, m.URL(link), nil
+}
+
+// URL returns the signed URL of a link
+func (m *Manager) URL(link *Link) string {
+	exp := strconv.FormatInt(link.ExpiresAt.Unix(), 10)
+	q := url.Values{}
+	q.Set("exp", exp)
+	q.Set("sig", m.sign(link.ID, link.Key, exp))
+	return m.opts.BaseURL + PathPrefix + link.ID + "?" + q.Encode()
+}
+
+// sign binds the link ID, file key and expiry together so none can be
+// altered without invalidating the URL
+func (m *Manager) sign(id, key, exp string) string {
+	mac := hmac.New(sha256.New, m.signKey)
+	fmt.Fprintf(mac, "v1\n%s\n%s\n%s", id, key, exp)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Authorize validates an access attempt and, when it is allowed, counts it
+// as a download. Every attempt is recorded in the audit log.
+func (m *Manager) Authorize(ctx context.Context, access Access) (*Link, error) {
+	m.mu.Lock()
+	stored, ok := m.links[access.ID]
+	var snapshot Link
+	if ok {
+		snapshot = *stored
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		m.audit(ctx, &Link{ID: access.ID}, "download", "denied", access, ErrNotFound)
+		return nil, ErrNotFound
+	}
+
+	// Signature and password checks are slow by design, so they run
+	// without holding the lock
+	if err := m.checkCredentials(&snapshot, access); err != nil {
+		m.audit(ctx, &snapshot, "download", "denied", access, err)
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link := m.links[access.ID]
+	if link == nil {
+		m.audit(ctx, &snapshot, "download", "denied", access, ErrNotFound)
+		return nil, ErrNotFound
+	}
+	if err := checkUsable(link, time.Now()); err != nil {
+		m.audit(ctx, link, "download", "denied", access, err)
+		return nil, err
+	}
+
+	link.Downloads++
+	link.LastAccessed = time.Now()
+	if err := m.saveLocked(); err != nil {
+		slog.Error("sharing: failed to persist download count", "link", link.ID, "error", err)
+	}
+	m.audit(ctx, link, "download", "success", access, nil)
+
+	copied := *link
+	return &copied, nil
+}
+
+func (m *Manager) checkCredentials(link *Link, access Access) error {
+	want := m.sign(link.ID, link.Key, access.Expires)
+	if subtle.ConstantTimeCompare([]byte(want), []byte(access.Signature)) != 1 {
+		return ErrInvalidSignature
+	}
+	// The signed expiry must match the stored one so a URL cannot claim a
+	// different lifetime than the link was issued with
+	if access.Expires != strconv.FormatInt(link.ExpiresAt.Unix(), 10) {
+		return ErrInvalidSignature
+	}
+	if err := checkUsable(link, time.Now()); err != nil {
+		return err
+	}
+	if link.HasPassword() {
+		if access.Password == "" {
+			return ErrPasswordRequired
+		}
+		hash, err := hashPassword(access.Password, link.PasswordSalt)
+		if err != nil || subtle.ConstantTimeCompare(hash, link.PasswordHash) != 1 {
+			return ErrPasswordMismatch
+		}
+	}
+	return nil
+}
+
+func checkUsable(link *Link, now time.Time) error {
+	if link.Revoked {
+		return ErrRevoked
+	}
+	if !now.Before(link.ExpiresAt) {
+		return ErrExpired
+	}
+	if link.MaxDownloads > 0 && link.Downloads >= link.MaxDownloads {
+		return ErrDownloadLimit
+	}
+	return nil
+}
+
+// Revoke disables a link immediately
+func (m *Manager) Revoke(ctx context.Context, id, by string) (*Link, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	link, ok := m.links[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !link.Revoked {
+		link.Revoked = true
+		link.RevokedAt = time.Now()
+		if err := m.saveLocked(); err != nil {
+			return nil, err
+		}
+	}
+	m.audit(ctx, link, "revoke", "success", Access{}, nil)
+
+	copied := *link
+	return &copied, nil
+}
+
+// Get returns a link by ID
+func (m *Manager) Get(id string) (*Link, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.links[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *link
+	return &copied, nil
+}
+
+// List returns the links for a file, or all links when key is empty, newest first
+func (m *Manager) List(key string) []Link {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	links := make([]Link, 0, len(m.links))
+	for _, link := range m.links {
+		if key == "" || link.Key == key {
+			links = append(links, *link)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].CreatedAt.After(links[j].CreatedAt) })
+	return links
+}
+
+// Prune drops links that expired or were revoked more than retain ago
+func (m *Manager) Prune(retain time.Duration) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := time.Now().Add(-retain)
+	removed := 0
+	for id, link := range m.links {
+		if link.ExpiresAt.Before(cutoff) || (link.Revoked && link.RevokedAt.Before(cutoff)) {
+			delete(m.links, id)
+			removed++
+		}
+	}
+	if removed > 0 {
+		if err := m.saveLocked(); err != nil {
+			slog.Error("sharing: failed to persist pruned links", "error", err)
+		}
+	}
+	return removed
+}
+
+func (m *Manager) audit(ctx context.Context, link *Link, action, result string, access Access, reason error) {
+	logger := m.opts.Audit
+	if logger == nil {
+		logger = audit.GlobalAuditLogger
+	}
+
+	details := map[string]interface{}{"link_id": link.ID}
+	if link.Key != "" {
+		details["downloads"] = link.Downloads
+		details["max_downloads"] = link.MaxDownloads
+	}
+	if reason != nil {
+		details["reason"] = reason.Error()
+	}
+
+	if logger == nil {
+		slog.Info("share link access", "link", link.ID, "key", link.Key, "action", action, "result", result, "ip", access.IPAddress)
+		return
+	}
+
+	level := audit.AuditLevelInfo
+	if result != "success" {
+		level = audit.AuditLevelWarning
+	}
+	event := &audit.AuditEvent{
+		Type:      audit.AuditEventTypeAccess,
+		Level:     level,
+		UserID:    link.CreatedBy,
+		IPAddress: access.IPAddress,
+		UserAgent: access.UserAgent,
+		Resource:  link.Key,
+		Action:    "share_link_" + action,
+		Result:    result,
+		Message:   fmt.Sprintf("Share link %s %s: %s", link.ID, action, result),
+		Details:   details,
+		Source:    "sharing",
+		Category:  "share_links",
+		Tags:      []string{"share", action, result},
+	}
+	if err := logger.LogEvent(ctx, event); err != nil {
+		slog.Warn("sharing: failed to write audit event", "error", err)
+	}
+}
+
+func (m *Manager) load() error {
+	if m.opts.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var links []*Link
+	if err := json.Unmarshal(data, &links); err != nil {
+		return fmt.Errorf("sharing: corrupt link store %s: %w", m.opts.Path, err)
+	}
+	for _, link := range links {
+		m.links[link.ID] = link
+	}
+	return nil
+}
+
+func (m *Manager) saveLocked() error {
+	if m.opts.Path == "" {
+		return nil
+	}
+	links := make([]*Link, 0, len(m.links))
+	for _, link := range m.links {
+		links = append(links, link)
+	}
+	data, err := json.MarshalIndent(links, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.opts.Path), 0700); err != nil {
+		return err
+	}
+	tmp := m.opts.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.opts.Path)
+}
+
+// ParseTTL parses a link lifetime such as "90m", "12h" or "7d"
+func ParseTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return DefaultTTL, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("sharing: invalid expiry %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("sharing: invalid expiry %q", s)
+	}
+	return d, nil
+}
+
+func hashPassword(password string, salt []byte) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, password, salt, passwordIterations, 32)
+}
+
+func randomID() (string, error) {
+	b := make([]byte, linkIDBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
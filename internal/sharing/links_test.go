@@ -0,0 +1,213 @@
+package sharing
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestManager(t *testing.T, path string) (*Manager, *audit.AuditLogger) {
+	t.Helper()
+	logger, err := audit.NewAuditLogger(filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	t.Cleanup(func() { logger.Close() })
+
+	m, err := NewManager(ManagerOpts{
+		Secret:  []byte("test-share-secret"),
+		Path:    path,
+		BaseURL: "https://vault.example.com",
+		Audit:   logger,
+	})
+	require.NoError(t, err)
+	return m, logger
+}
+
+// accessFromURL parses a generated link URL the way the gateway does
+func accessFromURL(t *testing.T, raw string) Access {
+	t.Helper()
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(u.Path, PathPrefix))
+	return Access{
+		ID:        strings.TrimPrefix(u.Path, PathPrefix),
+		Expires:   u.Query().Get("exp"),
+		Signature: u.Query().Get("sig"),
+		IPAddress: "203.0.113.7",
+	}
+}
+
+func TestManager_CreateAndAuthorize(t *testing.T) {
+	m, logger := newTestManager(t, "")
+	ctx := context.Background()
+
+	link, rawURL, err := m.Create(CreateOptions{Key: "report.pdf", CreatedBy: "alice"})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(rawURL, "https://vault.example.com/s/"+link.ID+"?"))
+	assert.WithinDuration(t, time.Now().Add(DefaultTTL), link.ExpiresAt, 2*time.Second)
+
+	got, err := m.Authorize(ctx, accessFromURL(t, rawURL))
+	require.NoError(t, err)
+	assert.Equal(t, "report.pdf", got.Key)
+	assert.Equal(t, 1, got.Downloads)
+
+	events := logger.GetEvents(&audit.AuditFilter{Resource: "report.pdf", Action: "share_link_download"})
+	require.Len(t, events, 1)
+	assert.Equal(t, "success", events[0].Result)
+	assert.Equal(t, "203.0.113.7", events[0].IPAddress)
+}
+
+func TestManager_RejectsTamperedURLs(t *testing.T) {
+	m, logger := newTestManager(t, "")
+	ctx := context.Background()
+
+	_, rawURL, err := m.Create(CreateOptions{Key: "report.pdf", TTL: time.Hour})
+	require.NoError(t, err)
+	access := accessFromURL(t, rawURL)
+
+	bad := access
+	bad.Signature = strings.Repeat("A", len(access.Signature))
+	_, err = m.Authorize(ctx, bad)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	// Extending the expiry invalidates the signature
+	bad = access
+	bad.Expires = "99999999999"
+	_, err = m.Authorize(ctx, bad)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	bad = access
+	bad.ID = "unknown"
+	_, err = m.Authorize(ctx, bad)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	denied := logger.GetEvents(&audit.AuditFilter{Result: "denied"})
+	assert.Len(t, denied, 3)
+}
+
+func TestManager_Expiry(t *testing.T) {
+	m, _ := newTestManager(t, "")
+
+	_, _, err := m.Create(CreateOptions{Key: "k", TTL: MaxTTL + time.Hour})
+	assert.ErrorIs(t, err, ErrInvalidExpiration)
+	_, _, err = m.Create(CreateOptions{Key: "k", TTL: -time.Minute})
+	assert.ErrorIs(t, err, ErrInvalidExpiration)
+
+	link, rawURL, err := m.Create(CreateOptions{Key: "k", TTL: time.Hour})
+	require.NoError(t, err)
+
+	// Age the stored link; the URL still carries the original signed expiry
+	m.mu.Lock()
+	m.links[link.ID].ExpiresAt = time.Now().Add(-time.Minute).Truncate(time.Second)
+	expired := *m.links[link.ID]
+	m.mu.Unlock()
+
+	_, err = m.Authorize(context.Background(), accessFromURL(t, m.URL(&expired)))
+	assert.ErrorIs(t, err, ErrExpired)
+	_, err = m.Authorize(context.Background(), accessFromURL(t, rawURL))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
+
+func TestManager_DownloadLimit(t *testing.T) {
+	m, _ := newTestManager(t, "")
+	ctx := context.Background()
+
+	link, rawURL, err := m.Create(CreateOptions{Key: "k", MaxDownloads: 2})
+	require.NoError(t, err)
+	access := accessFromURL(t, rawURL)
+
+	for i := 0; i < 2; i++ {
+		_, err := m.Authorize(ctx, access)
+		require.NoError(t, err)
+	}
+	_, err = m.Authorize(ctx, access)
+	assert.ErrorIs(t, err, ErrDownloadLimit)
+
+	got, err := m.Get(link.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.Downloads)
+	assert.False(t, got.Active(time.Now()))
+}
+
+func TestManager_Password(t *testing.T) {
+	m, _ := newTestManager(t, "")
+	ctx := context.Background()
+
+	link, rawURL, err := m.Create(CreateOptions{Key: "k", Password: "hunter2"})
+	require.NoError(t, err)
+	assert.True(t, link.HasPassword())
+	access := accessFromURL(t, rawURL)
+
+	_, err = m.Authorize(ctx, access)
+	assert.ErrorIs(t, err, ErrPasswordRequired)
+
+	access.Password = "wrong"
+	_, err = m.Authorize(ctx, access)
+	assert.ErrorIs(t, err, ErrPasswordMismatch)
+
+	access.Password = "hunter2"
+	got, err := m.Authorize(ctx, access)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.Downloads)
+}
+
+func TestManager_RevokeAndPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.json")
+	m, logger := newTestManager(t, path)
+	ctx := context.Background()
+
+	first, firstURL, err := m.Create(CreateOptions{Key: "a", Password: "pw"})
+	require.NoError(t, err)
+	_, _, err = m.Create(CreateOptions{Key: "b"})
+	require.NoError(t, err)
+
+	_, err = m.Revoke(ctx, first.ID, "admin")
+	require.NoError(t, err)
+	_, err = m.Revoke(ctx, "missing", "admin")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	access := accessFromURL(t, firstURL)
+	access.Password = "pw"
+	_, err = m.Authorize(ctx, access)
+	assert.ErrorIs(t, err, ErrRevoked)
+	assert.Len(t, logger.GetEvents(&audit.AuditFilter{Action: "share_link_revoke"}), 1)
+
+	reloaded, _ := newTestManager(t, path)
+	assert.Len(t, reloaded.List(""), 2)
+	require.Len(t, reloaded.List("a"), 1)
+	assert.True(t, reloaded.List("a")[0].Revoked)
+	_, err = reloaded.Authorize(ctx, access)
+	assert.ErrorIs(t, err, ErrRevoked)
+
+	assert.Equal(t, 0, reloaded.Prune(time.Hour))
+	assert.Equal(t, 1, reloaded.Prune(-time.Hour))
+	assert.Len(t, reloaded.List(""), 1)
+}
+
+func TestNewManager_RequiresSecret(t *testing.T) {
+	_, err := NewManager(ManagerOpts{})
+	assert.Error(t, err)
+}
+
+func TestParseTTL(t *testing.T) {
+	d, err := ParseTTL("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultTTL, d)
+
+	d, err = ParseTTL("7d")
+	require.NoError(t, err)
+	assert.Equal(t, 7*24*time.Hour, d)
+
+	d, err = ParseTTL("90m")
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Minute, d)
+
+	_, err = ParseTTL("soon")
+	assert.Error(t, err)
+}
@@ -0,0 +1,90 @@
+package sim
+
+import (
+	"fmt"
+	"time"
+)
+
+// Process is a participant of a world, such as a node of a cluster. It
+// gives the code running there the world's clock and randomness, and runs
+// its timers and background work as events of the world.
+type Process struct {
+	world *World
+	name  string
+	down  bool
+	epoch int // bumped on crashes, dropping the events scheduled before
+}
+
+// Process returns the process with the given name, creating it
+func (w *World) Process(name string) *Process {
+	if p, ok := w.processes[name]; ok {
+		return p
+	}
+	p := &Process{world: w, name: name}
+	w.processes[name] = p
+	return p
+}
+
+// Name returns the name of the process
+func (p *Process) Name() string { return p.name }
+
+// Now returns the virtual time
+func (p *Process) Now() time.Time { return p.world.now }
+
+// Int64N returns a random number in [0, n) from the world's source
+func (p *Process) Int64N(n int64) int64 { return p.world.rng.Int64N(n) }
+
+// Go runs f after a random latency, as background work whose scheduling
+// the world decides
+func (p *Process) Go(f func()) {
+	p.After(p.world.latency(), f)
+}
+
+// After runs f once d of virtual time passed, unless the process crashes
+// first
+func (p *Process) After(d time.Duration, f func()) {
+	p.world.schedule(d, p, p.epoch, f)
+}
+
+// Every calls f every interval until stop is called or the process
+// crashes
+func (p *Process) Every(interval time.Duration, f func()) (stop func()) {
+	if interval <= 0 {
+		panic(fmt.Sprintf("sim: non-positive interval %s", interval))
+	}
+	stopped := false
+	var tick func()
+	tick = func() {
+		if stopped {
+			return
+		}
+		f()
+		p.After(interval, tick)
+	}
+	p.After(interval, tick)
+	return func() { stopped = true }
+}
+
+// Up reports whether the process is running
+func (p *Process) Up() bool { return !p.down }
+
+// Crash stops the process: the events it scheduled are dropped and the
+// messages sent to it lost, until Restart
+func (p *Process) Crash() {
+	if p.down {
+		return
+	}
+	p.down = true
+	p.epoch++
+	p.world.Tracef("%s crashed", p.name)
+}
+
+// Restart brings a crashed process back; the code running there starts
+// again from its persisted state
+func (p *Process) Restart() {
+	if !p.down {
+		return
+	}
+	p.down = false
+	p.world.Tracef("%s restarted", p.name)
+}
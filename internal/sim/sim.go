@@ -0,0 +1,261 @@
+// Package sim runs distributed protocols deterministically. A World holds
+// a virtual clock, a random source seeded once and a queue of events; the
+// processes living in it schedule their timers and background work as
+// events and ask the world whether each message they send gets through.
+// Events run one at a time, in time order and then in the order they were
+// scheduled, so a run depends only on the seed and the script driving it:
+// a failure found with one seed replays exactly with the same seed.
+//
+// The network between processes delays messages by a random latency,
+// drops a fraction of them, and drops every message across a partition or
+// to a crashed process. Crashing a process also drops the events it had
+// scheduled, as a crashed machine forgets the work it had in flight.
+package sim
+
+import (
+	"container/heap"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Epoch is the virtual time worlds start at
+var Epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Network is how the world treats messages between processes
+type Network struct {
+	// MinLatency and MaxLatency bound the delay of each message and of the
+	// background work processes start
+	MinLatency time.Duration `yaml:"min_latency" json:"min_latency"`
+	MaxLatency time.Duration `yaml:"max_latency" json:"max_latency"`
+	// Drop is the probability, from 0 to 1, of losing a message
+	Drop float64 `yaml:"drop" json:"drop"`
+}
+
+// Validate checks the latencies and the drop probability
+func (n Network) Validate() error {
+	if n.MinLatency < 0 || n.MaxLatency < n.MinLatency {
+		return fmt.Errorf("sim: invalid latency range %s-%s", n.MinLatency, n.MaxLatency)
+	}
+	if n.Drop < 0 || n.Drop > 1 {
+		return fmt.Errorf("sim: drop probability %v is not between 0 and 1", n.Drop)
+	}
+	return nil
+}
+
+// World is a simulated cluster: the clock, randomness and network its
+// processes share. It is not safe for concurrent use; everything runs on
+// the goroutine calling Step or Run.
+type World struct {
+	seed      uint64
+	now       time.Time
+	rng       *rand.Rand
+	events    eventQueue
+	seq       uint64
+	network   Network
+	processes map[string]*Process
+	groups    map[string]int // partition group of each process
+	observers []func()
+	trace     []string
+	traceSum  [sha256.Size]byte
+}
+
+// New creates a world starting at Epoch with the given seed
+func New(seed uint64) *World {
+	return &World{
+		seed:      seed,
+		now:       Epoch,
+		rng:       rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15)),
+		processes: make(map[string]*Process),
+	}
+}
+
+// Seed returns the seed of the world
+func (w *World) Seed() uint64 { return w.seed }
+
+// Now returns the virtual time
+func (w *World) Now() time.Time { return w.now }
+
+// Elapsed returns the virtual time since Epoch
+func (w *World) Elapsed() time.Duration { return w.now.Sub(Epoch) }
+
+// Int64N returns a random number in [0, n) from the world's source
+func (w *World) Int64N(n int64) int64 { return w.rng.Int64N(n) }
+
+// Float64 returns a random number in [0, 1) from the world's source
+func (w *World) Float64() float64 { return w.rng.Float64() }
+
+// SetNetwork changes how messages are delayed and dropped
+func (w *World) SetNetwork(n Network) error {
+	if err := n.Validate(); err != nil {
+		return err
+	}
+	w.network = n
+	return nil
+}
+
+// Network returns how messages are delayed and dropped
+func (w *World) Network() Network { return w.network }
+
+// latency draws the delay of a message
+func (w *World) latency() time.Duration {
+	spread := w.network.MaxLatency - w.network.MinLatency
+	if spread <= 0 {
+		return w.network.MinLatency
+	}
+	return w.network.MinLatency + time.Duration(w.rng.Int64N(int64(spread)+1))
+}
+
+// After schedules f to run d from now
+func (w *World) After(d time.Duration, f func()) {
+	w.schedule(d, nil, 0, f)
+}
+
+func (w *World) schedule(d time.Duration, p *Process, epoch int, f func()) {
+	w.seq++
+	heap.Push(&w.events, &event{at: w.now.Add(max(d, 0)), seq: w.seq, process: p, epoch: epoch, run: f})
+}
+
+// Observe calls f after every event, to check invariants as the world
+// runs
+func (w *World) Observe(f func()) { w.observers = append(w.observers, f) }
+
+// Step runs the next event, reporting whether there was one
+func (w *World) Step() bool {
+	for w.events.Len() > 0 {
+		e := heap.Pop(&w.events).(*event)
+		w.now = e.at
+		if e.process != nil && (e.process.down || e.process.epoch != e.epoch) {
+			continue
+		}
+		e.run()
+		for _, f := range w.observers {
+			f()
+		}
+		return true
+	}
+	return false
+}
+
+// Run runs the events of the next d of virtual time, then moves the clock
+// to its end
+func (w *World) Run(d time.Duration) {
+	end := w.now.Add(d)
+	for w.events.Len() > 0 && !w.events[0].at.After(end) {
+		w.Step()
+	}
+	w.now = end
+}
+
+// RunUntil runs events until cond holds, checking it after each one, or
+// until limit of virtual time passed. It reports whether cond held.
+func (w *World) RunUntil(cond func() bool, limit time.Duration) bool {
+	end := w.now.Add(limit)
+	for !cond() {
+		if w.events.Len() == 0 || w.events[0].at.After(end) {
+			w.now = end
+			return cond()
+		}
+		w.Step()
+	}
+	return true
+}
+
+// Partition splits the processes into groups that cannot reach each
+// other. Processes left out of every group reach all others.
+func (w *World) Partition(groups ...[]string) {
+	w.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, name := range group {
+			w.groups[name] = i
+		}
+	}
+	w.Tracef("partition %v", groups)
+}
+
+// Heal lifts the partitions
+func (w *World) Heal() {
+	w.groups = nil
+	w.Tracef("heal")
+}
+
+// Reachable reports whether a partition separates the processes
+func (w *World) Reachable(from, to string) bool {
+	gf, okF := w.groups[from]
+	gt, okT := w.groups[to]
+	return !okF || !okT || gf == gt
+}
+
+// Deliver decides the fate of a message from one process to another:
+// whether it arrives, and after what delay. Messages to crashed or
+// unreachable processes are lost, and others with the network's drop
+// probability.
+func (w *World) Deliver(from, to string) (time.Duration, bool) {
+	if p, ok := w.processes[to]; !ok || p.down {
+		return 0, false
+	}
+	if p, ok := w.processes[from]; ok && p.down {
+		return 0, false
+	}
+	if !w.Reachable(from, to) {
+		return 0, false
+	}
+	if w.network.Drop > 0 && w.rng.Float64() < w.network.Drop {
+		return 0, false
+	}
+	return w.latency(), true
+}
+
+// Tracef records a line in the trace of the world, stamped with the
+// virtual time
+func (w *World) Tracef(format string, args ...any) {
+	line := fmt.Sprintf("%10.3fs %s", w.Elapsed().Seconds(), fmt.Sprintf(format, args...))
+	w.trace = append(w.trace, line)
+	w.traceSum = sha256.Sum256(append(w.traceSum[:], line...))
+}
+
+// Trace returns the lines traced so far
+func (w *World) Trace() []string { return append([]string(nil), w.trace...) }
+
+// Fingerprint summarizes the trace: two runs with the same fingerprint
+// went through the same events
+func (w *World) Fingerprint() string { return hex.EncodeToString(w.traceSum[:8]) }
+
+// String describes the world for failure messages
+func (w *World) String() string {
+	return fmt.Sprintf("seed %d at %s", w.seed, w.Elapsed())
+}
+
+// event is work scheduled at a virtual time
+type event struct {
+	at      time.Time
+	seq     uint64
+	process *Process // nil for the world's own events
+	epoch   int      // of the process when scheduled
+	run     func()
+}
+
+// eventQueue orders events by time, then by scheduling order
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if !q[i].at.Equal(q[j].at) {
+		return q[i].at.Before(q[j].at)
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *eventQueue) Push(x any) { *q = append(*q, x.(*event)) }
+
+func (q *eventQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,97 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventsRunInTimeOrder(t *testing.T) {
+	w := New(1)
+	var order []string
+	w.After(20*time.Millisecond, func() { order = append(order, "b") })
+	w.After(10*time.Millisecond, func() { order = append(order, "a") })
+	w.After(20*time.Millisecond, func() { order = append(order, "c") })
+	w.After(time.Second, func() { order = append(order, "late") })
+
+	w.Run(100 * time.Millisecond)
+	assert.Equal(t, []string{"a", "b", "c"}, order, "ties run in scheduling order")
+	assert.Equal(t, 100*time.Millisecond, w.Elapsed())
+
+	assert.True(t, w.RunUntil(func() bool { return len(order) == 4 }, 2*time.Second))
+	assert.Equal(t, time.Second, w.Elapsed())
+	assert.False(t, w.Step())
+}
+
+func TestProcessCrashDropsItsEvents(t *testing.T) {
+	w := New(1)
+	p := w.Process("a")
+	ticks := 0
+	stop := p.Every(10*time.Millisecond, func() { ticks++ })
+	ran := false
+	p.After(50*time.Millisecond, func() { ran = true })
+
+	w.Run(35 * time.Millisecond)
+	assert.Equal(t, 3, ticks)
+	p.Crash()
+	w.Run(100 * time.Millisecond)
+	assert.Equal(t, 3, ticks)
+	assert.False(t, ran, "work scheduled before the crash is lost")
+
+	p.Restart()
+	stop = p.Every(10*time.Millisecond, func() { ticks++ })
+	w.Run(25 * time.Millisecond)
+	assert.Equal(t, 5, ticks)
+	stop()
+	w.Run(100 * time.Millisecond)
+	assert.Equal(t, 5, ticks)
+}
+
+func TestDeliver(t *testing.T) {
+	w := New(1)
+	a, b := w.Process("a"), w.Process("b")
+	require.NoError(t, w.SetNetwork(Network{MinLatency: time.Millisecond, MaxLatency: 5 * time.Millisecond}))
+	assert.Error(t, w.SetNetwork(Network{Drop: 2}))
+
+	d, ok := w.Deliver("a", "b")
+	assert.True(t, ok)
+	assert.GreaterOrEqual(t, d, time.Millisecond)
+	assert.LessOrEqual(t, d, 5*time.Millisecond)
+
+	w.Partition([]string{"a"}, []string{"b"})
+	_, ok = w.Deliver("a", "b")
+	assert.False(t, ok)
+	w.Heal()
+	b.Crash()
+	_, ok = w.Deliver("a", "b")
+	assert.False(t, ok)
+	b.Restart()
+	a.Crash()
+	_, ok = w.Deliver("a", "b")
+	assert.False(t, ok, "crashed processes send nothing")
+	_, ok = w.Deliver("a", "nobody")
+	assert.False(t, ok)
+}
+
+func TestSameSeedSameRun(t *testing.T) {
+	run := func(seed uint64) string {
+		w := New(seed)
+		require.NoError(t, w.SetNetwork(Network{MaxLatency: 10 * time.Millisecond, Drop: 0.3}))
+		for _, name := range []string{"a", "b", "c"} {
+			p := w.Process(name)
+			p.Every(7*time.Millisecond, func() {
+				for _, to := range []string{"a", "b", "c"} {
+					if d, ok := w.Deliver(name, to); ok {
+						p.After(d, func() { w.Tracef("%s -> %s", name, to) })
+					}
+				}
+			})
+		}
+		w.Run(time.Second)
+		return w.Fingerprint()
+	}
+	assert.Equal(t, run(42), run(42))
+	assert.NotEqual(t, run(42), run(43))
+}
@@ -0,0 +1,143 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/backup"
+)
+
+// ChangeType is how a key differs between two states of a namespace
+type ChangeType string
+
+const (
+	ChangeAdded    ChangeType = "added"
+	ChangeRemoved  ChangeType = "removed"
+	ChangeModified ChangeType = "modified"
+)
+
+// Change is a key that differs between two states
+type Change struct {
+	Key  string        `json:"key"`
+	Type ChangeType    `json:"type"`
+	From *backup.Entry `json:"from,omitempty"`
+	To   *backup.Entry `json:"to,omitempty"`
+}
+
+// Diff lists what changed from one snapshot to another, or to the live
+// namespace
+type Diff struct {
+	From     string   `json:"from"`
+	To       string   `json:"to"`
+	Added    int      `json:"added"`
+	Removed  int      `json:"removed"`
+	Modified int      `json:"modified"`
+	Changes  []Change `json:"changes"`
+}
+
+// Live names the current state of a namespace in a Diff
+const Live = "live"
+
+// Diff compares the snapshot from with the snapshot to, or with the live
+// namespace of from when to is empty or Live. Entries are compared by
+// content hash, so metadata-only updates are not changes.
+func (m *Manager) Diff(ctx context.Context, from, to string) (*Diff, error) {
+	a, err := m.Get(from)
+	if err != nil {
+		return nil, err
+	}
+	var entries []backup.Entry
+	if to == "" || to == Live {
+		to = Live
+		if entries, err = m.opts.Source.List(ctx, a.Namespace); err != nil {
+			return nil, fmt.Errorf("snapshot: listing %q: %w", a.Namespace, err)
+		}
+	} else {
+		b, err := m.Get(to)
+		if err != nil {
+			return nil, err
+		}
+		entries = b.Entries
+	}
+
+	d := &Diff{From: from, To: to, Changes: []Change{}}
+	current := make(map[string]backup.Entry, len(entries))
+	for _, e := range entries {
+		current[e.Key] = e
+	}
+	for _, old := range a.Entries {
+		cur, ok := current[old.Key]
+		switch {
+		case !ok:
+			d.Changes = append(d.Changes, Change{Key: old.Key, Type: ChangeRemoved, From: &old})
+			d.Removed++
+		case cur.Hash != old.Hash || cur.Size != old.Size:
+			d.Changes = append(d.Changes, Change{Key: old.Key, Type: ChangeModified, From: &old, To: &cur})
+			d.Modified++
+		}
+		delete(current, old.Key)
+	}
+	for _, cur := range current {
+		d.Changes = append(d.Changes, Change{Key: cur.Key, Type: ChangeAdded, To: &cur})
+		d.Added++
+	}
+	sort.Slice(d.Changes, func(i, j int) bool { return d.Changes[i].Key < d.Changes[j].Key })
+	return d, nil
+}
+
+// CloneReport summarises a clone
+type CloneReport struct {
+	Snapshot string    `json:"snapshot"`
+	Prefix   string    `json:"prefix"`
+	Cloned   int       `json:"cloned"`
+	Skipped  int       `json:"skipped"`
+	Failed   int       `json:"failed"`
+	Bytes    int64     `json:"bytes"`
+	Errors   []string  `json:"errors,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// Clone writes the objects of a snapshot under prefix in place of its
+// namespace, e.g. projects/a/x to projects/a-copy/x. Cloning with the
+// namespace as prefix rolls the namespace back, overwriting only with
+// overwrite set.
+func (m *Manager) Clone(ctx context.Context, id, prefix string, overwrite bool) (*CloneReport, error) {
+	if m.opts.Sink == nil {
+		return nil, errors.New("snapshot: clones are not supported")
+	}
+	snap, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	report := &CloneReport{Snapshot: id, Prefix: prefix, Started: time.Now().UTC()}
+	for _, e := range snap.Entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		data, err := m.read(ctx, e)
+		if err == nil {
+			target := e
+			target.Key = prefix + strings.TrimPrefix(e.Key, snap.Namespace)
+			err = m.opts.Sink.Restore(ctx, target, bytes.NewReader(data), overwrite)
+		}
+		switch {
+		case err == nil:
+			report.Cloned++
+			report.Bytes += e.Size
+		case errors.Is(err, backup.ErrExists):
+			report.Skipped++
+		default:
+			report.Failed++
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", e.Key, err))
+		}
+	}
+	report.Finished = time.Now().UTC()
+	m.logger.Info("Snapshot cloned", "snapshot", id, "prefix", prefix, "cloned", report.Cloned, "failed", report.Failed)
+	return report, nil
+}
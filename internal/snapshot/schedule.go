@@ -0,0 +1,163 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/backup"
+	"gopkg.in/yaml.v3"
+)
+
+// Schedule takes snapshots of a namespace on a cron schedule
+type Schedule struct {
+	Name string `yaml:"name" json:"name"`
+	// Namespace is the key prefix to snapshot; empty means every key
+	Namespace string `yaml:"namespace" json:"namespace"`
+	// Cron is a cron expression such as "0 * * * *" or "@daily"
+	Cron string `yaml:"schedule" json:"schedule"`
+	// Keep is the number of snapshots of the schedule to keep; 0 keeps
+	// everything
+	Keep int `yaml:"keep,omitempty" json:"keep,omitempty"`
+
+	cron *backup.Schedule
+}
+
+// Config is the snapshot section of the API configuration
+type Config struct {
+	// Path holds the manifests and preserved content; empty keeps them in
+	// memory
+	Path      string      `yaml:"path"`
+	Schedules []*Schedule `yaml:"schedules"`
+}
+
+// LoadConfig reads the snapshot configuration from a YAML file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("snapshot: invalid config %s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate checks every schedule and parses its cron expression
+func (c *Config) Validate() error {
+	seen := make(map[string]bool)
+	for _, s := range c.Schedules {
+		if s.Name == "" {
+			return errors.New("snapshot: schedule name is required")
+		}
+		if seen[s.Name] {
+			return fmt.Errorf("snapshot: duplicate schedule %q", s.Name)
+		}
+		seen[s.Name] = true
+		if s.Keep < 0 {
+			return fmt.Errorf("snapshot: schedule %q: keep must not be negative", s.Name)
+		}
+		cron, err := backup.ParseSchedule(s.Cron)
+		if err != nil {
+			return fmt.Errorf("snapshot: schedule %q: %w", s.Name, err)
+		}
+		s.cron = cron
+	}
+	return nil
+}
+
+// Start takes scheduled snapshots until Stop is called
+func (m *Manager) Start() {
+	m.mu.Lock()
+	if m.stop != nil || len(m.opts.Schedules) == 0 {
+		m.mu.Unlock()
+		return
+	}
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	stop, done := m.stop, m.done
+	m.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		next := make(map[string]time.Time)
+		for {
+			now := time.Now()
+			var earliest time.Time
+			for _, s := range m.opts.Schedules {
+				if s.cron == nil {
+					continue
+				}
+				at, ok := next[s.Name]
+				if !ok {
+					at = s.cron.Next(now)
+					next[s.Name] = at
+				}
+				if !at.After(now) {
+					m.runSchedule(s)
+					at = s.cron.Next(now)
+					next[s.Name] = at
+				}
+				if !at.IsZero() && (earliest.IsZero() || at.Before(earliest)) {
+					earliest = at
+				}
+			}
+
+			wait := time.Minute
+			if !earliest.IsZero() {
+				wait = max(time.Until(earliest), 0)
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// Stop stops scheduling
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	stop, done := m.stop, m.done
+	m.stop, m.done = nil, nil
+	m.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// runSchedule takes a snapshot for a schedule and prunes its oldest
+// snapshots beyond Keep
+func (m *Manager) runSchedule(s *Schedule) {
+	if _, err := m.create(context.Background(), s.Namespace, s.Name, s.Name); err != nil {
+		m.logger.Error("Scheduled snapshot failed", "schedule", s.Name, "error", err)
+		return
+	}
+	if s.Keep <= 0 {
+		return
+	}
+
+	// List is oldest first
+	var ids []string
+	for _, snap := range m.List() {
+		if snap.Schedule == s.Name {
+			ids = append(ids, snap.ID)
+		}
+	}
+	for len(ids) > s.Keep {
+		if err := m.Delete(ids[0]); err != nil {
+			m.logger.Warn("Failed to prune snapshot", "snapshot", ids[0], "error", err)
+		}
+		ids = ids[1:]
+	}
+}
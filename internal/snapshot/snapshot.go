@@ -0,0 +1,452 @@
+// Package snapshot takes point-in-time snapshots of a namespace. A snapshot
+// is a manifest of the keys under a prefix and the SHA-256 of their content;
+// nothing is copied when it is taken. Content is copied on write instead:
+// before a key a snapshot references is overwritten or deleted, the content
+// the snapshot recorded is preserved, once per hash.
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/backup"
+)
+
+var (
+	// ErrNotFound is returned for unknown snapshots
+	ErrNotFound = errors.New("snapshot: not found")
+	// ErrKeyNotFound is returned for keys a snapshot does not hold
+	ErrKeyNotFound = errors.New("snapshot: key not in snapshot")
+	// ErrContentLost is returned when the content a snapshot recorded was
+	// changed without being preserved, e.g. on another node
+	ErrContentLost = errors.New("snapshot: content is no longer available")
+)
+
+// Snapshot is the state of a namespace at a point in time. Entries are the
+// objects of the namespace, their Hash the SHA-256 of their content.
+type Snapshot struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Namespace string    `json:"namespace"`
+	CreatedAt time.Time `json:"created_at"`
+	// Schedule is the schedule that took the snapshot; empty for snapshots
+	// taken on demand
+	Schedule string         `json:"schedule,omitempty"`
+	Files    int            `json:"files"`
+	Size     int64          `json:"size"`
+	Entries  []backup.Entry `json:"entries,omitempty"`
+}
+
+// Options configures a Manager
+type Options struct {
+	// Source lists and reads the live objects
+	Source backup.Source
+	// Sink writes the objects of clones
+	Sink backup.Sink
+	// Path holds the manifests and preserved content; empty keeps them in
+	// memory
+	Path string
+	// Schedules take snapshots on cron schedules once Start is called
+	Schedules []*Schedule
+	Logger    *slog.Logger
+}
+
+// Manager takes, serves and deletes snapshots
+type Manager struct {
+	opts   Options
+	logger *slog.Logger
+
+	mu    sync.RWMutex
+	snaps map[string]*Snapshot
+	// refs counts the snapshots referencing each hash of each key
+	refs map[string]map[string]int
+	// blobs holds preserved content by hash when there is no Path
+	blobs map[string][]byte
+
+	// preserving serialises copy-on-write, so a hash is preserved once
+	preserving sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewManager loads the snapshots under opts.Path
+func NewManager(opts Options) (*Manager, error) {
+	if opts.Source == nil {
+		return nil, errors.New("snapshot: a source is required")
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if err := (&Config{Schedules: opts.Schedules}).Validate(); err != nil {
+		return nil, err
+	}
+	m := &Manager{
+		opts:   opts,
+		logger: opts.Logger,
+		snaps:  make(map[string]*Snapshot),
+		refs:   make(map[string]map[string]int),
+		blobs:  make(map[string][]byte),
+	}
+	if opts.Path == "" {
+		return m, nil
+	}
+	if err := os.MkdirAll(filepath.Join(opts.Path, "blobs"), 0700); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(opts.Path, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		var snap Snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("snapshot: invalid manifest %s: %w", p, err)
+		}
+		m.add(&snap)
+	}
+	return m, nil
+}
+
+// add indexes a snapshot; callers hold mu or own m exclusively
+func (m *Manager) add(snap *Snapshot) {
+	m.snaps[snap.ID] = snap
+	for _, e := range snap.Entries {
+		hashes, ok := m.refs[e.Key]
+		if !ok {
+			hashes = make(map[string]int)
+			m.refs[e.Key] = hashes
+		}
+		hashes[e.Hash]++
+	}
+}
+
+// Create takes a snapshot of the objects whose keys start with namespace
+func (m *Manager) Create(ctx context.Context, namespace, name string) (*Snapshot, error) {
+	return m.create(ctx, namespace, name, "")
+}
+
+func (m *Manager) create(ctx context.Context, namespace, name, schedule string) (*Snapshot, error) {
+	entries, err := m.opts.Source.List(ctx, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: listing %q: %w", namespace, err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+
+	snap := &Snapshot{
+		ID:        newID(time.Now()),
+		Name:      name,
+		Namespace: namespace,
+		CreatedAt: time.Now().UTC(),
+		Schedule:  schedule,
+		Entries:   make([]backup.Entry, 0, len(entries)),
+	}
+	for _, e := range entries {
+		if e.Hash == "" {
+			// Sources that do not report hashes are hashed here, as
+			// copy-on-write compares content by hash
+			if e.Hash, e.Size, err = m.hashLive(ctx, e.Key); err != nil {
+				return nil, fmt.Errorf("snapshot: %s: %w", e.Key, err)
+			}
+		}
+		snap.Entries = append(snap.Entries, e)
+		snap.Files++
+		snap.Size += e.Size
+	}
+
+	if err := m.save(snap); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	m.add(snap)
+	m.mu.Unlock()
+
+	m.logger.Info("Snapshot created", "snapshot", snap.ID, "namespace", namespace, "files", snap.Files)
+	created := *snap
+	created.Entries = append([]backup.Entry(nil), snap.Entries...)
+	return &created, nil
+}
+
+func (m *Manager) hashLive(ctx context.Context, key string) (string, int64, error) {
+	rc, err := m.opts.Source.Open(ctx, key)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() { _ = rc.Close() }()
+	h := sha256.New()
+	n, err := io.Copy(h, rc)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+func (m *Manager) save(snap *Snapshot) error {
+	if m.opts.Path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	p := filepath.Join(m.opts.Path, snap.ID+".json")
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// List returns every snapshot, oldest first, without their entries
+func (m *Manager) List() []Snapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]Snapshot, 0, len(m.snaps))
+	for _, snap := range m.snaps {
+		s := *snap
+		s.Entries = nil
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// Get returns a snapshot with its entries
+func (m *Manager) Get(id string) (*Snapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	snap, ok := m.snaps[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	s := *snap
+	s.Entries = append([]backup.Entry(nil), snap.Entries...)
+	return &s, nil
+}
+
+// Delete deletes a snapshot and the content only it preserved
+func (m *Manager) Delete(id string) error {
+	m.preserving.Lock()
+	defer m.preserving.Unlock()
+
+	m.mu.Lock()
+	snap, ok := m.snaps[id]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	delete(m.snaps, id)
+	var released []string
+	for _, e := range snap.Entries {
+		hashes := m.refs[e.Key]
+		hashes[e.Hash]--
+		if hashes[e.Hash] <= 0 {
+			delete(hashes, e.Hash)
+			if !m.referencedLocked(e.Hash) {
+				released = append(released, e.Hash)
+			}
+		}
+		if len(hashes) == 0 {
+			delete(m.refs, e.Key)
+		}
+	}
+	for _, hash := range released {
+		delete(m.blobs, hash)
+	}
+	m.mu.Unlock()
+
+	if m.opts.Path == "" {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(m.opts.Path, id+".json")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, hash := range released {
+		if err := os.Remove(m.blobPath(hash)); err != nil && !os.IsNotExist(err) {
+			m.logger.Warn("Failed to remove preserved snapshot content", "hash", hash, "error", err)
+		}
+	}
+	return nil
+}
+
+// referencedLocked reports whether any key of any snapshot has the hash
+func (m *Manager) referencedLocked(hash string) bool {
+	for _, hashes := range m.refs {
+		if hashes[hash] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Preserve is called before the content of key is overwritten or deleted.
+// If a snapshot recorded the current content, read returns it and it is
+// kept for the snapshot. An error means the content could not be kept and
+// the change should not go ahead.
+func (m *Manager) Preserve(ctx context.Context, key string, read func() ([]byte, error)) error {
+	m.mu.RLock()
+	pinned := len(m.refs[key]) > 0
+	m.mu.RUnlock()
+	if !pinned {
+		return nil
+	}
+
+	m.preserving.Lock()
+	defer m.preserving.Unlock()
+	data, err := read()
+	if err != nil {
+		// Nothing to preserve when the content is already gone
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	m.mu.RLock()
+	wanted := m.refs[key][hash] > 0
+	_, kept := m.blobs[hash]
+	m.mu.RUnlock()
+	if !wanted || kept || m.hasBlobFile(hash) {
+		return nil
+	}
+
+	if m.opts.Path != "" {
+		tmp := m.blobPath(hash) + ".tmp"
+		if err := os.WriteFile(tmp, data, 0600); err != nil {
+			return fmt.Errorf("snapshot: preserving %s: %w", key, err)
+		}
+		if err := os.Rename(tmp, m.blobPath(hash)); err != nil {
+			return fmt.Errorf("snapshot: preserving %s: %w", key, err)
+		}
+	} else {
+		m.mu.Lock()
+		m.blobs[hash] = bytes.Clone(data)
+		m.mu.Unlock()
+	}
+	m.logger.Debug("Preserved snapshot content", "key", key, "hash", hash)
+	return nil
+}
+
+func (m *Manager) blobPath(hash string) string {
+	return filepath.Join(m.opts.Path, "blobs", hash)
+}
+
+func (m *Manager) hasBlobFile(hash string) bool {
+	if m.opts.Path == "" {
+		return false
+	}
+	_, err := os.Stat(m.blobPath(hash))
+	return err == nil
+}
+
+// Open reads the content of key as a snapshot recorded it
+func (m *Manager) Open(ctx context.Context, id, key string) (io.ReadCloser, *backup.Entry, error) {
+	snap, err := m.Get(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	i := sort.Search(len(snap.Entries), func(i int) bool { return snap.Entries[i].Key >= key })
+	if i == len(snap.Entries) || snap.Entries[i].Key != key {
+		return nil, nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	entry := snap.Entries[i]
+	data, err := m.read(ctx, entry)
+	if err != nil {
+		return nil, nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), &entry, nil
+}
+
+// read returns the preserved content of an entry, or the live content while
+// it is unchanged
+func (m *Manager) read(ctx context.Context, entry backup.Entry) ([]byte, error) {
+	m.mu.RLock()
+	data, ok := m.blobs[entry.Hash]
+	m.mu.RUnlock()
+	if ok {
+		return data, nil
+	}
+	if m.opts.Path != "" {
+		data, err := os.ReadFile(m.blobPath(entry.Hash))
+		if err == nil {
+			return data, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	rc, err := m.opts.Source.Open(ctx, entry.Key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrContentLost, entry.Key)
+	}
+	defer func() { _ = rc.Close() }()
+	data, err = io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != entry.Hash {
+		return nil, fmt.Errorf("%w: %s", ErrContentLost, entry.Key)
+	}
+	return data, nil
+}
+
+// Source returns the objects of a snapshot as a backup source, so backups
+// and clones see the namespace as it was
+func (m *Manager) Source(id string) (backup.Source, error) {
+	snap, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshotSource{m: m, snap: snap}, nil
+}
+
+type snapshotSource struct {
+	m    *Manager
+	snap *Snapshot
+}
+
+func (s *snapshotSource) List(ctx context.Context, prefix string) ([]backup.Entry, error) {
+	var entries []backup.Entry
+	for _, e := range s.snap.Entries {
+		if strings.HasPrefix(e.Key, prefix) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func (s *snapshotSource) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	rc, _, err := s.m.Open(ctx, s.snap.ID, key)
+	return rc, err
+}
+
+// newID returns a time-ordered, unique snapshot ID
+func newID(now time.Time) string {
+	var suffix [3]byte
+	_, _ = rand.Read(suffix[:])
+	return now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix[:])
+}
@@ -0,0 +1,269 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Skpow1234/Peervault/internal/backup"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore is a live namespace whose writes preserve snapshot content first,
+// as the file service does
+type memStore struct {
+	files map[string][]byte
+	snaps *Manager
+}
+
+func newMemStore() *memStore {
+	return &memStore{files: make(map[string][]byte)}
+}
+
+func hashOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *memStore) List(ctx context.Context, prefix string) ([]backup.Entry, error) {
+	var entries []backup.Entry
+	for key, data := range s.files {
+		if strings.HasPrefix(key, prefix) {
+			entries = append(entries, backup.Entry{Key: key, Size: int64(len(data)), Hash: hashOf(data)})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, nil
+}
+
+func (s *memStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	data, ok := s.files[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memStore) Restore(ctx context.Context, entry backup.Entry, r io.Reader, overwrite bool) error {
+	if _, ok := s.files[entry.Key]; ok && !overwrite {
+		return backup.ErrExists
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return s.put(entry.Key, data)
+}
+
+func (s *memStore) put(key string, data []byte) error {
+	if s.snaps != nil {
+		if err := s.snaps.Preserve(context.Background(), key, s.read(key)); err != nil {
+			return err
+		}
+	}
+	s.files[key] = data
+	return nil
+}
+
+func (s *memStore) delete(key string) error {
+	if s.snaps != nil {
+		if err := s.snaps.Preserve(context.Background(), key, s.read(key)); err != nil {
+			return err
+		}
+	}
+	delete(s.files, key)
+	return nil
+}
+
+func (s *memStore) read(key string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		data, ok := s.files[key]
+		if !ok {
+			return nil, errors.New("not found")
+		}
+		return data, nil
+	}
+}
+
+func newTestManager(t *testing.T, path string) (*Manager, *memStore) {
+	t.Helper()
+	store := newMemStore()
+	m, err := NewManager(Options{Source: store, Sink: store, Path: path})
+	require.NoError(t, err)
+	store.snaps = m
+	return m, store
+}
+
+func readAll(t *testing.T, m *Manager, id, key string) string {
+	t.Helper()
+	rc, _, err := m.Open(context.Background(), id, key)
+	require.NoError(t, err)
+	defer func() { _ = rc.Close() }()
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestCopyOnWrite(t *testing.T) {
+	for name, path := range map[string]string{"memory": "", "disk": t.TempDir()} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			m, store := newTestManager(t, path)
+			require.NoError(t, store.put("docs/a.txt", []byte("v1")))
+			require.NoError(t, store.put("docs/b.txt", []byte("b")))
+			require.NoError(t, store.put("other/c.txt", []byte("c")))
+
+			snap, err := m.Create(ctx, "docs/", "before-edit")
+			require.NoError(t, err)
+			assert.Equal(t, 2, snap.Files)
+			assert.Equal(t, int64(3), snap.Size)
+
+			require.NoError(t, store.put("docs/a.txt", []byte("v2")))
+			require.NoError(t, store.delete("docs/b.txt"))
+
+			assert.Equal(t, "v1", readAll(t, m, snap.ID, "docs/a.txt"))
+			assert.Equal(t, "b", readAll(t, m, snap.ID, "docs/b.txt"))
+			_, _, err = m.Open(ctx, snap.ID, "other/c.txt")
+			assert.ErrorIs(t, err, ErrKeyNotFound)
+
+			require.NoError(t, m.Delete(snap.ID))
+			_, err = m.Get(snap.ID)
+			assert.ErrorIs(t, err, ErrNotFound)
+			if path != "" {
+				blobs, err := os.ReadDir(filepath.Join(path, "blobs"))
+				require.NoError(t, err)
+				assert.Empty(t, blobs, "preserved content is released with the snapshot")
+			}
+		})
+	}
+}
+
+func TestUnpreservedChangeIsReported(t *testing.T) {
+	m, store := newTestManager(t, "")
+	require.NoError(t, store.put("a", []byte("v1")))
+	snap, err := m.Create(context.Background(), "", "")
+	require.NoError(t, err)
+
+	// Written behind the manager's back
+	store.files["a"] = []byte("v2")
+	_, _, err = m.Open(context.Background(), snap.ID, "a")
+	assert.ErrorIs(t, err, ErrContentLost)
+}
+
+func TestDiff(t *testing.T) {
+	ctx := context.Background()
+	m, store := newTestManager(t, "")
+	require.NoError(t, store.put("a", []byte("1")))
+	require.NoError(t, store.put("b", []byte("2")))
+	first, err := m.Create(ctx, "", "")
+	require.NoError(t, err)
+
+	require.NoError(t, store.put("a", []byte("changed")))
+	require.NoError(t, store.delete("b"))
+	require.NoError(t, store.put("c", []byte("3")))
+
+	live, err := m.Diff(ctx, first.ID, "")
+	require.NoError(t, err)
+	assert.Equal(t, Live, live.To)
+	assert.Equal(t, []ChangeType{ChangeModified, ChangeRemoved, ChangeAdded}, changeTypes(live))
+
+	second, err := m.Create(ctx, "", "")
+	require.NoError(t, err)
+	d, err := m.Diff(ctx, first.ID, second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, live.Changes, d.Changes)
+
+	d, err = m.Diff(ctx, second.ID, Live)
+	require.NoError(t, err)
+	assert.Empty(t, d.Changes)
+}
+
+func changeTypes(d *Diff) []ChangeType {
+	types := make([]ChangeType, len(d.Changes))
+	for i, c := range d.Changes {
+		types[i] = c.Type
+	}
+	return types
+}
+
+func TestClone(t *testing.T) {
+	ctx := context.Background()
+	m, store := newTestManager(t, "")
+	require.NoError(t, store.put("proj/a", []byte("a1")))
+	require.NoError(t, store.put("proj/b", []byte("b1")))
+	snap, err := m.Create(ctx, "proj/", "")
+	require.NoError(t, err)
+	require.NoError(t, store.put("proj/a", []byte("a2")))
+
+	report, err := m.Clone(ctx, snap.ID, "proj-copy/", false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Cloned)
+	assert.Equal(t, "a1", string(store.files["proj-copy/a"]))
+
+	// Rolling back skips existing keys unless asked to overwrite
+	report, err = m.Clone(ctx, snap.ID, "proj/", false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Skipped)
+	report, err = m.Clone(ctx, snap.ID, "proj/", true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Cloned)
+	assert.Equal(t, "a1", string(store.files["proj/a"]))
+}
+
+func TestSourceAndReload(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	m, store := newTestManager(t, dir)
+	require.NoError(t, store.put("a", []byte("v1")))
+	snap, err := m.Create(ctx, "", "nightly")
+	require.NoError(t, err)
+	require.NoError(t, store.put("a", []byte("v2")))
+
+	reloaded, err := NewManager(Options{Source: store, Path: dir})
+	require.NoError(t, err)
+	require.Len(t, reloaded.List(), 1)
+	assert.Equal(t, "nightly", reloaded.List()[0].Name)
+
+	src, err := reloaded.Source(snap.ID)
+	require.NoError(t, err)
+	entries, err := src.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	rc, err := src.Open(ctx, "a")
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(data))
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := &Config{Schedules: []*Schedule{{Name: "hourly", Namespace: "docs/", Cron: "@hourly", Keep: 24}}}
+	require.NoError(t, cfg.Validate())
+	assert.NotNil(t, cfg.Schedules[0].cron)
+
+	assert.Error(t, (&Config{Schedules: []*Schedule{{Cron: "@hourly"}}}).Validate())
+	assert.Error(t, (&Config{Schedules: []*Schedule{{Name: "a", Cron: "every hour"}}}).Validate())
+	assert.Error(t, (&Config{Schedules: []*Schedule{{Name: "a", Cron: "@daily"}, {Name: "a", Cron: "@daily"}}}).Validate())
+	assert.Error(t, (&Config{Schedules: []*Schedule{{Name: "a", Cron: "@daily", Keep: -1}}}).Validate())
+}
+
+func TestRunSchedulePrunes(t *testing.T) {
+	m, store := newTestManager(t, "")
+	require.NoError(t, store.put("a", []byte("1")))
+	s := &Schedule{Name: "often", Cron: "* * * * *", Keep: 2}
+	for range 3 {
+		m.runSchedule(s)
+	}
+	snaps := m.List()
+	assert.Len(t, snaps, 2)
+	assert.Equal(t, "often", snaps[0].Schedule)
+}
@@ -0,0 +1,702 @@
+package sqlquery
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scope is what an expression is evaluated on: a row, and for grouped
+// queries the rows of its group
+type scope struct {
+	row   []Value
+	group [][]Value
+	// aliases are the values, or expressions, of the selected columns
+	aliases map[string]Value
+}
+
+// aggregates are the functions computed over the rows of a group
+var aggregates = map[string]bool{"count": true, "sum": true, "avg": true, "min": true, "max": true, "total": true}
+
+// hasAggregate reports whether e calls an aggregate
+func hasAggregate(e expr) bool {
+	switch e := e.(type) {
+	case *callExpr:
+		if aggregates[e.name] {
+			return true
+		}
+		for _, a := range e.args {
+			if hasAggregate(a) {
+				return true
+			}
+		}
+	case *unaryExpr:
+		return hasAggregate(e.x)
+	case *binaryExpr:
+		return hasAggregate(e.l) || hasAggregate(e.r)
+	case *isNullExpr:
+		return hasAggregate(e.x)
+	case *likeExpr:
+		return hasAggregate(e.x) || hasAggregate(e.pattern)
+	case *betweenExpr:
+		return hasAggregate(e.x) || hasAggregate(e.lo) || hasAggregate(e.hi)
+	case *inExpr:
+		if hasAggregate(e.x) {
+			return true
+		}
+		for _, a := range e.list {
+			if hasAggregate(a) {
+				return true
+			}
+		}
+	case *caseExpr:
+		for _, w := range e.whens {
+			if hasAggregate(w.cond) || hasAggregate(w.then) {
+				return true
+			}
+		}
+		return e.els != nil && hasAggregate(e.els)
+	}
+	return false
+}
+
+func (q *query) eval(e expr, s *scope) (Value, error) {
+	switch e := e.(type) {
+	case *literal:
+		return e.v, nil
+	case *columnRef:
+		if i, ok := q.cols[e.name]; ok {
+			if s.row == nil {
+				return nil, nil
+			}
+			return s.row[i], nil
+		}
+		if v, ok := s.aliases[e.name]; ok {
+			if a, ok := v.(aliasExpr); ok {
+				return q.eval(a.expr, s)
+			}
+			return v, nil
+		}
+		return nil, fmt.Errorf("%w: no column %q in %s", ErrInvalid, e.name, q.table.Name)
+	case *unaryExpr:
+		x, err := q.eval(e.x, s)
+		if err != nil || x == nil {
+			return nil, err
+		}
+		if e.op == "NOT" {
+			b, null := truth(x)
+			if null {
+				return nil, nil
+			}
+			return !b, nil
+		}
+		switch x := x.(type) {
+		case int64:
+			return -x, nil
+		case float64:
+			return -x, nil
+		}
+		return nil, fmt.Errorf("%w: cannot negate %s", ErrInvalid, typeName(x))
+	case *binaryExpr:
+		return q.evalBinary(e, s)
+	case *isNullExpr:
+		x, err := q.eval(e.x, s)
+		return (x == nil) != e.not, err
+	case *likeExpr:
+		x, err := q.eval(e.x, s)
+		if err != nil {
+			return nil, err
+		}
+		pattern, err := q.eval(e.pattern, s)
+		if err != nil || x == nil || pattern == nil {
+			return nil, err
+		}
+		return like(text(x), text(pattern)) != e.not, nil
+	case *inExpr:
+		x, err := q.eval(e.x, s)
+		if err != nil || x == nil {
+			return nil, err
+		}
+		for _, item := range e.list {
+			v, err := q.eval(item, s)
+			if err != nil {
+				return nil, err
+			}
+			if v != nil && compare(x, v) == 0 {
+				return !e.not, nil
+			}
+		}
+		return e.not, nil
+	case *betweenExpr:
+		x, err := q.eval(e.x, s)
+		if err != nil {
+			return nil, err
+		}
+		lo, err := q.eval(e.lo, s)
+		if err != nil {
+			return nil, err
+		}
+		hi, err := q.eval(e.hi, s)
+		if err != nil || x == nil || lo == nil || hi == nil {
+			return nil, err
+		}
+		return (compare(x, lo) >= 0 && compare(x, hi) <= 0) != e.not, nil
+	case *caseExpr:
+		for _, w := range e.whens {
+			cond, err := q.eval(w.cond, s)
+			if err != nil {
+				return nil, err
+			}
+			if ok, _ := truth(cond); ok {
+				return q.eval(w.then, s)
+			}
+		}
+		if e.els == nil {
+			return nil, nil
+		}
+		return q.eval(e.els, s)
+	case *callExpr:
+		if aggregates[e.name] {
+			return q.aggregate(e, s)
+		}
+		return q.call(e, s)
+	}
+	return nil, fmt.Errorf("%w: unsupported expression", ErrInvalid)
+}
+
+func (q *query) evalBinary(e *binaryExpr, s *scope) (Value, error) {
+	l, err := q.eval(e.l, s)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "AND", "OR":
+		// Three-valued logic: FALSE AND NULL is FALSE, TRUE OR NULL is TRUE
+		lb, lnull := truth(l)
+		if !lnull && lb == (e.op == "OR") {
+			return lb, nil
+		}
+		r, err := q.eval(e.r, s)
+		if err != nil {
+			return nil, err
+		}
+		rb, rnull := truth(r)
+		if !rnull && rb == (e.op == "OR") {
+			return rb, nil
+		}
+		if lnull || rnull {
+			return nil, nil
+		}
+		return rb, nil
+	}
+	r, err := q.eval(e.r, s)
+	if err != nil || l == nil || r == nil {
+		return nil, err
+	}
+	switch e.op {
+	case "=":
+		return compare(l, r) == 0, nil
+	case "!=":
+		return compare(l, r) != 0, nil
+	case "<":
+		return compare(l, r) < 0, nil
+	case "<=":
+		return compare(l, r) <= 0, nil
+	case ">":
+		return compare(l, r) > 0, nil
+	case ">=":
+		return compare(l, r) >= 0, nil
+	case "||":
+		return text(l) + text(r), nil
+	}
+	return arithmetic(e.op, l, r)
+}
+
+func arithmetic(op string, l, r Value) (Value, error) {
+	li, lInt := l.(int64)
+	ri, rInt := r.(int64)
+	if lInt && rInt {
+		switch op {
+		case "+":
+			return li + ri, nil
+		case "-":
+			return li - ri, nil
+		case "*":
+			return li * ri, nil
+		case "/", "%":
+			if ri == 0 {
+				return nil, nil
+			}
+			if op == "/" {
+				return li / ri, nil
+			}
+			return li % ri, nil
+		}
+	}
+	lf, lok := number(l)
+	rf, rok := number(r)
+	if !lok || !rok {
+		return nil, fmt.Errorf("%w: cannot compute %s %s %s", ErrInvalid, typeName(l), op, typeName(r))
+	}
+	switch op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, nil
+		}
+		return lf / rf, nil
+	default:
+		if rf == 0 {
+			return nil, nil
+		}
+		return math.Mod(lf, rf), nil
+	}
+}
+
+// aggregate computes an aggregate over the rows of the group of s
+func (q *query) aggregate(e *callExpr, s *scope) (Value, error) {
+	if s.group == nil && s.row != nil {
+		return nil, fmt.Errorf("%w: aggregate %s is not allowed here", ErrInvalid, strings.ToUpper(e.name))
+	}
+	if e.star {
+		if e.name != "count" {
+			return nil, fmt.Errorf("%w: %s(*) is not supported", ErrInvalid, strings.ToUpper(e.name))
+		}
+		return int64(len(s.group)), nil
+	}
+	if len(e.args) != 1 {
+		return nil, fmt.Errorf("%w: %s takes one argument", ErrInvalid, strings.ToUpper(e.name))
+	}
+	if hasAggregate(e.args[0]) {
+		return nil, fmt.Errorf("%w: aggregates cannot be nested", ErrInvalid)
+	}
+
+	var values []Value
+	seen := make(map[string]bool)
+	for _, row := range s.group {
+		v, err := q.eval(e.args[0], &scope{row: row, aliases: s.aliases})
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+		if e.distinct {
+			k := key([]Value{v})
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+		}
+		values = append(values, v)
+	}
+
+	switch e.name {
+	case "count":
+		return int64(len(values)), nil
+	case "min", "max":
+		var best Value
+		for _, v := range values {
+			if c := compare(v, best); best == nil || (e.name == "min" && c < 0) || (e.name == "max" && c > 0) {
+				best = v
+			}
+		}
+		return best, nil
+	}
+	// sum, total and avg
+	var sumInt int64
+	var sumFloat float64
+	isFloat := e.name != "sum"
+	for _, v := range values {
+		switch v := v.(type) {
+		case int64:
+			sumInt += v
+			sumFloat += float64(v)
+		case float64:
+			isFloat = true
+			sumFloat += v
+		default:
+			return nil, fmt.Errorf("%w: cannot %s %s values", ErrInvalid, strings.ToUpper(e.name), typeName(v))
+		}
+	}
+	switch {
+	case e.name == "total":
+		return sumFloat, nil
+	case len(values) == 0:
+		return nil, nil
+	case e.name == "avg":
+		return sumFloat / float64(len(values)), nil
+	case isFloat:
+		return sumFloat, nil
+	}
+	return sumInt, nil
+}
+
+// call calls a scalar function
+func (q *query) call(e *callExpr, s *scope) (Value, error) {
+	if e.star || e.distinct {
+		return nil, fmt.Errorf("%w: %s does not take * or DISTINCT", ErrInvalid, strings.ToUpper(e.name))
+	}
+	args := make([]Value, len(e.args))
+	for i, a := range e.args {
+		v, err := q.eval(a, s)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	arity := func(lo, hi int) error {
+		if len(args) < lo || len(args) > hi {
+			return fmt.Errorf("%w: wrong number of arguments to %s", ErrInvalid, strings.ToUpper(e.name))
+		}
+		return nil
+	}
+	anyNull := func() bool {
+		for _, a := range args {
+			if a == nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch e.name {
+	case "coalesce", "ifnull":
+		for _, a := range args {
+			if a != nil {
+				return a, nil
+			}
+		}
+		return nil, nil
+	case "now":
+		return q.now, arity(0, 0)
+	case "meta":
+		if err := arity(1, 1); err != nil || anyNull() {
+			return nil, err
+		}
+		m, err := q.column(s, "metadata")
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := m.(map[string]string)[text(args[0])]; ok {
+			return v, nil
+		}
+		return nil, nil
+	case "has_tag":
+		if err := arity(1, 1); err != nil || anyNull() {
+			return nil, err
+		}
+		tags, err := q.column(s, "tags")
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tags.([]string) {
+			if t == text(args[0]) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	if anyNull() {
+		return nil, nil
+	}
+	switch e.name {
+	case "lower", "upper", "length":
+		if err := arity(1, 1); err != nil {
+			return nil, err
+		}
+		switch e.name {
+		case "lower":
+			return strings.ToLower(text(args[0])), nil
+		case "upper":
+			return strings.ToUpper(text(args[0])), nil
+		}
+		switch v := args[0].(type) {
+		case []string:
+			return int64(len(v)), nil
+		case map[string]string:
+			return int64(len(v)), nil
+		}
+		return int64(len([]rune(text(args[0])))), nil
+	case "substr":
+		if err := arity(2, 3); err != nil {
+			return nil, err
+		}
+		runes := []rune(text(args[0]))
+		start, ok := args[1].(int64)
+		if !ok {
+			return nil, fmt.Errorf("%w: SUBSTR start must be an integer", ErrInvalid)
+		}
+		from := max(int(start)-1, 0)
+		to := len(runes)
+		if len(args) == 3 {
+			n, ok := args[2].(int64)
+			if !ok {
+				return nil, fmt.Errorf("%w: SUBSTR length must be an integer", ErrInvalid)
+			}
+			to = min(from+max(int(n), 0), len(runes))
+		}
+		if from >= len(runes) {
+			return "", nil
+		}
+		return string(runes[from:to]), nil
+	case "split_part":
+		if err := arity(3, 3); err != nil {
+			return nil, err
+		}
+		n, ok := args[2].(int64)
+		if !ok || n < 1 {
+			return nil, fmt.Errorf("%w: SPLIT_PART field must be a positive integer", ErrInvalid)
+		}
+		parts := strings.Split(text(args[0]), text(args[1]))
+		if int(n) > len(parts) {
+			return "", nil
+		}
+		return parts[n-1], nil
+	case "date":
+		if err := arity(1, 1); err != nil {
+			return nil, err
+		}
+		t, ok := toTime(args[0])
+		if !ok {
+			return nil, fmt.Errorf("%w: DATE of %s", ErrInvalid, typeName(args[0]))
+		}
+		return t.UTC().Format(time.DateOnly), nil
+	case "abs":
+		if err := arity(1, 1); err != nil {
+			return nil, err
+		}
+		switch v := args[0].(type) {
+		case int64:
+			if v < 0 {
+				return -v, nil
+			}
+			return v, nil
+		case float64:
+			return math.Abs(v), nil
+		}
+		return nil, fmt.Errorf("%w: ABS of %s", ErrInvalid, typeName(args[0]))
+	case "round":
+		if err := arity(1, 2); err != nil {
+			return nil, err
+		}
+		f, ok := number(args[0])
+		if !ok {
+			return nil, fmt.Errorf("%w: ROUND of %s", ErrInvalid, typeName(args[0]))
+		}
+		digits := int64(0)
+		if len(args) == 2 {
+			if digits, ok = args[1].(int64); !ok {
+				return nil, fmt.Errorf("%w: ROUND digits must be an integer", ErrInvalid)
+			}
+		}
+		scale := math.Pow(10, float64(digits))
+		return math.Round(f*scale) / scale, nil
+	}
+	return nil, fmt.Errorf("%w: no function %s", ErrInvalid, strings.ToUpper(e.name))
+}
+
+// column returns a column of the row of s by name, for the functions that
+// read a column implicitly
+func (q *query) column(s *scope, name string) (Value, error) {
+	i, ok := q.cols[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has no %s column", ErrInvalid, q.table.Name, name)
+	}
+	if s.row == nil {
+		return nil, nil
+	}
+	return s.row[i], nil
+}
+
+// truth returns the truth of v and whether it is NULL. Numbers are true
+// when not zero; other values are false.
+func truth(v Value) (bool, bool) {
+	switch v := v.(type) {
+	case nil:
+		return false, true
+	case bool:
+		return v, false
+	case int64:
+		return v != 0, false
+	case float64:
+		return v != 0, false
+	}
+	return false, false
+}
+
+func number(v Value) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// toTime converts times and strings holding an RFC 3339 time or a date
+func toTime(v Value) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", time.DateOnly} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// text is the string form of a value
+func text(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case []string:
+		return strings.Join(v, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+func typeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return "integer"
+	case float64:
+		return "real"
+	case string:
+		return "text"
+	case bool:
+		return "boolean"
+	case time.Time:
+		return "timestamp"
+	case []string:
+		return "list"
+	case map[string]string:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// rank orders values of different types: NULL, then booleans, numbers,
+// times and text
+func rank(v Value) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case int64, float64:
+		return 2
+	case time.Time:
+		return 3
+	}
+	return 4
+}
+
+// compare orders two values. Times compare with strings holding times, so
+// created_at > '2024-01-01' works; other values of different types are
+// ordered by type.
+func compare(a, b Value) int {
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := toTime(b); ok {
+			return ta.Compare(tb)
+		}
+	}
+	if tb, ok := b.(time.Time); ok {
+		if ta, ok := toTime(a); ok {
+			return ta.Compare(tb)
+		}
+	}
+	if ra, rb := rank(a), rank(b); ra != rb {
+		return ra - rb
+	}
+	switch a := a.(type) {
+	case nil:
+		return 0
+	case bool:
+		bb := b.(bool)
+		switch {
+		case a == bb:
+			return 0
+		case !a:
+			return -1
+		}
+		return 1
+	case int64:
+		if bi, ok := b.(int64); ok {
+			switch {
+			case a < bi:
+				return -1
+			case a > bi:
+				return 1
+			}
+			return 0
+		}
+	}
+	if fa, ok := number(a); ok {
+		fb, _ := number(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(text(a), text(b))
+}
+
+// like matches s against a LIKE pattern, where % matches any run of
+// characters and _ one character, ignoring the case of ASCII letters
+func like(s, pattern string) bool {
+	sr, pr := []rune(strings.ToLower(s)), []rune(strings.ToLower(pattern))
+	// Positions to resume from after the last %
+	si, pi, star, mark := 0, 0, -1, 0
+	for si < len(sr) {
+		switch {
+		case pi < len(pr) && (pr[pi] == '_' || pr[pi] == sr[si]):
+			si++
+			pi++
+		case pi < len(pr) && pr[pi] == '%':
+			star, mark = pi, si
+			pi++
+		case star >= 0:
+			pi = star + 1
+			mark++
+			si = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(pr) && pr[pi] == '%' {
+		pi++
+	}
+	return pi == len(pr)
+}
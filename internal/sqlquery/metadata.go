@@ -0,0 +1,57 @@
+package sqlquery
+
+import "github.com/Skpow1234/Peervault/internal/metadata"
+
+// MetadataTables returns the tables over file records: objects, with a row
+// per file, and versions, with a row per noncurrent version. Text columns
+// a record leaves empty are NULL.
+func MetadataTables(records []metadata.FileRecord) []Table {
+	objects := Table{
+		Name: "objects",
+		Columns: []string{
+			"key", "name", "size", "content_type", "hash", "owner", "tenant",
+			"tags", "metadata", "storage_class", "versions", "version_bytes",
+			"created_at", "updated_at",
+		},
+		Doc: "One row per file. tenant is the tenant metadata entry or else the owner; meta('k') reads a metadata entry and has_tag('t') tests a tag.",
+	}
+	versions := Table{
+		Name:    "versions",
+		Columns: []string{"key", "tenant", "version_id", "size", "hash", "storage_class", "created_at", "noncurrent_since"},
+		Doc:     "One row per noncurrent version of a file.",
+	}
+	for _, rec := range records {
+		var versionBytes int64
+		for _, v := range rec.Versions {
+			versionBytes += v.Size
+			class := v.StorageClass
+			if class == "" {
+				class = metadata.StorageClassHot
+			}
+			versions.Rows = append(versions.Rows, []Value{
+				rec.Key, orNull(rec.Tenant()), v.ID, v.Size, orNull(v.Hash), class, v.CreatedAt, v.NoncurrentSince,
+			})
+		}
+		tags := rec.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		meta := rec.Metadata
+		if meta == nil {
+			meta = map[string]string{}
+		}
+		objects.Rows = append(objects.Rows, []Value{
+			rec.Key, orNull(rec.Name), rec.Size, orNull(rec.ContentType), orNull(rec.Hash), orNull(rec.Owner), orNull(rec.Tenant()),
+			tags, meta, rec.Class(), int64(len(rec.Versions)), versionBytes,
+			rec.CreatedAt, rec.UpdatedAt,
+		})
+	}
+	return []Table{objects, versions}
+}
+
+func orNull(s string) Value {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,510 @@
+package sqlquery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// expr is a node of an expression
+type expr interface{}
+
+type literal struct{ v Value }
+
+type columnRef struct{ name string }
+
+type unaryExpr struct {
+	op string
+	x  expr
+}
+
+type binaryExpr struct {
+	op   string
+	l, r expr
+}
+
+type isNullExpr struct {
+	x   expr
+	not bool
+}
+
+type inExpr struct {
+	x    expr
+	list []expr
+	not  bool
+}
+
+type betweenExpr struct {
+	x, lo, hi expr
+	not       bool
+}
+
+type likeExpr struct {
+	x, pattern expr
+	not        bool
+}
+
+type callExpr struct {
+	name     string
+	args     []expr
+	star     bool
+	distinct bool
+}
+
+type whenClause struct{ cond, then expr }
+
+type caseExpr struct {
+	whens []whenClause
+	els   expr
+}
+
+type selectItem struct {
+	expr expr
+	// name is the alias, or else the text of the expression
+	name string
+}
+
+type orderItem struct {
+	expr expr
+	desc bool
+}
+
+// selectStmt is a parsed query
+type selectStmt struct {
+	distinct bool
+	star     bool
+	items    []selectItem
+	from     string
+	where    expr
+	groupBy  []expr
+	having   expr
+	orderBy  []orderItem
+	// limit is -1 without LIMIT
+	limit, offset int64
+}
+
+type parser struct {
+	src    string
+	tokens []token
+	i      int
+}
+
+// parse parses a SELECT statement
+func parse(src string) (*selectStmt, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: src, tokens: tokens}
+	stmt, err := p.selectStmt()
+	if err != nil {
+		return nil, err
+	}
+	p.acceptOp(";")
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, syntaxError(t.pos, fmt.Sprintf("unexpected %s", t))
+	}
+	return stmt, nil
+}
+
+func (p *parser) peek() token { return p.tokens[p.i] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) acceptKeyword(words ...string) bool {
+	for j, w := range words {
+		if t := p.tokens[min(p.i+j, len(p.tokens)-1)]; t.kind != tokKeyword || t.text != w {
+			return false
+		}
+	}
+	p.i += len(words)
+	return true
+}
+
+func (p *parser) acceptOp(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(w string) error {
+	if !p.acceptKeyword(w) {
+		t := p.peek()
+		return syntaxError(t.pos, fmt.Sprintf("expected %s, got %s", w, t))
+	}
+	return nil
+}
+
+func (p *parser) expectOp(op string) error {
+	if !p.acceptOp(op) {
+		t := p.peek()
+		return syntaxError(t.pos, fmt.Sprintf("expected %q, got %s", op, t))
+	}
+	return nil
+}
+
+func (p *parser) selectStmt() (*selectStmt, error) {
+	if t := p.peek(); t.kind == tokKeyword && t.text != "SELECT" {
+		return nil, fmt.Errorf("%w: only SELECT queries are supported, got %s", ErrInvalid, t.text)
+	}
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	stmt := &selectStmt{limit: -1}
+	stmt.distinct = p.acceptKeyword("DISTINCT")
+	if p.acceptOp("*") {
+		stmt.star = true
+	} else {
+		for {
+			start := p.peek().pos
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			item := selectItem{expr: e, name: strings.TrimSpace(p.src[start:p.peek().pos])}
+			if col, ok := e.(*columnRef); ok {
+				item.name = col.name
+			}
+			if p.acceptKeyword("AS") {
+				t := p.next()
+				if t.kind != tokIdent && t.kind != tokString {
+					return nil, syntaxError(t.pos, fmt.Sprintf("expected an alias, got %s", t))
+				}
+				item.name = t.text
+			} else if t := p.peek(); t.kind == tokIdent {
+				item.name = p.next().text
+			}
+			stmt.items = append(stmt.items, item)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	t := p.next()
+	if t.kind != tokIdent {
+		return nil, syntaxError(t.pos, fmt.Sprintf("expected a table, got %s", t))
+	}
+	stmt.from = t.text
+
+	var err error
+	if p.acceptKeyword("WHERE") {
+		if stmt.where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("GROUP", "BY") {
+		if stmt.groupBy, err = p.exprList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("HAVING") {
+		if stmt.having, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("ORDER", "BY") {
+		for {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			item := orderItem{expr: e}
+			if p.acceptKeyword("DESC") {
+				item.desc = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			stmt.orderBy = append(stmt.orderBy, item)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKeyword("LIMIT") {
+		if stmt.limit, err = p.count(); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("OFFSET") {
+			if stmt.offset, err = p.count(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return stmt, nil
+}
+
+// count parses the non-negative integer of LIMIT and OFFSET
+func (p *parser) count() (int64, error) {
+	t := p.next()
+	n, err := strconv.ParseInt(t.text, 10, 64)
+	if t.kind != tokNumber || err != nil || n < 0 {
+		return 0, syntaxError(t.pos, fmt.Sprintf("expected a non-negative integer, got %s", t))
+	}
+	return n, nil
+}
+
+func (p *parser) exprList() ([]expr, error) {
+	var list []expr
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+		if !p.acceptOp(",") {
+			return list, nil
+		}
+	}
+}
+
+func (p *parser) expr() (expr, error) { return p.or() }
+
+func (p *parser) or() (expr, error) {
+	l, err := p.and()
+	for err == nil && p.acceptKeyword("OR") {
+		var r expr
+		if r, err = p.and(); err == nil {
+			l = &binaryExpr{op: "OR", l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *parser) and() (expr, error) {
+	l, err := p.not()
+	for err == nil && p.acceptKeyword("AND") {
+		var r expr
+		if r, err = p.not(); err == nil {
+			l = &binaryExpr{op: "AND", l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *parser) not() (expr, error) {
+	if p.acceptKeyword("NOT") {
+		x, err := p.not()
+		return &unaryExpr{op: "NOT", x: x}, err
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (expr, error) {
+	l, err := p.concat()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == tokOp && (t.text == "=" || t.text == "!=" || t.text == "<>" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="):
+			p.next()
+			r, err := p.concat()
+			if err != nil {
+				return nil, err
+			}
+			op := t.text
+			if op == "<>" {
+				op = "!="
+			}
+			l = &binaryExpr{op: op, l: l, r: r}
+		case p.acceptKeyword("IS"):
+			not := p.acceptKeyword("NOT")
+			if err := p.expectKeyword("NULL"); err != nil {
+				return nil, err
+			}
+			l = &isNullExpr{x: l, not: not}
+		default:
+			not := p.acceptKeyword("NOT")
+			switch {
+			case p.acceptKeyword("LIKE"):
+				pattern, err := p.concat()
+				if err != nil {
+					return nil, err
+				}
+				l = &likeExpr{x: l, pattern: pattern, not: not}
+			case p.acceptKeyword("IN"):
+				if err := p.expectOp("("); err != nil {
+					return nil, err
+				}
+				list, err := p.exprList()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expectOp(")"); err != nil {
+					return nil, err
+				}
+				l = &inExpr{x: l, list: list, not: not}
+			case p.acceptKeyword("BETWEEN"):
+				lo, err := p.concat()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expectKeyword("AND"); err != nil {
+					return nil, err
+				}
+				hi, err := p.concat()
+				if err != nil {
+					return nil, err
+				}
+				l = &betweenExpr{x: l, lo: lo, hi: hi, not: not}
+			default:
+				if not {
+					t := p.peek()
+					return nil, syntaxError(t.pos, fmt.Sprintf("expected LIKE, IN or BETWEEN after NOT, got %s", t))
+				}
+				return l, nil
+			}
+		}
+	}
+}
+
+func (p *parser) concat() (expr, error) {
+	l, err := p.additive()
+	for err == nil && p.acceptOp("||") {
+		var r expr
+		if r, err = p.additive(); err == nil {
+			l = &binaryExpr{op: "||", l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *parser) additive() (expr, error) {
+	l, err := p.multiplicative()
+	for err == nil {
+		t := p.peek()
+		if t.kind != tokOp || (t.text != "+" && t.text != "-") {
+			break
+		}
+		p.next()
+		var r expr
+		if r, err = p.multiplicative(); err == nil {
+			l = &binaryExpr{op: t.text, l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *parser) multiplicative() (expr, error) {
+	l, err := p.unary()
+	for err == nil {
+		t := p.peek()
+		if t.kind != tokOp || (t.text != "*" && t.text != "/" && t.text != "%") {
+			break
+		}
+		p.next()
+		var r expr
+		if r, err = p.unary(); err == nil {
+			l = &binaryExpr{op: t.text, l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *parser) unary() (expr, error) {
+	if p.acceptOp("-") {
+		x, err := p.unary()
+		return &unaryExpr{op: "-", x: x}, err
+	}
+	if p.acceptOp("+") {
+		return p.unary()
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		if n, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return &literal{n}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, syntaxError(t.pos, fmt.Sprintf("invalid number %q", t.text))
+		}
+		return &literal{f}, nil
+	case tokString:
+		return &literal{t.text}, nil
+	case tokKeyword:
+		switch t.text {
+		case "NULL":
+			return &literal{nil}, nil
+		case "TRUE":
+			return &literal{true}, nil
+		case "FALSE":
+			return &literal{false}, nil
+		case "CASE":
+			return p.caseExpr()
+		}
+	case tokIdent:
+		if !p.acceptOp("(") {
+			return &columnRef{name: t.text}, nil
+		}
+		call := &callExpr{name: t.text}
+		if p.acceptOp("*") {
+			call.star = true
+		} else if !p.acceptOp(")") {
+			call.distinct = p.acceptKeyword("DISTINCT")
+			args, err := p.exprList()
+			if err != nil {
+				return nil, err
+			}
+			call.args = args
+		} else {
+			return call, nil
+		}
+		return call, p.expectOp(")")
+	case tokOp:
+		if t.text == "(" {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expectOp(")")
+		}
+	}
+	return nil, syntaxError(t.pos, fmt.Sprintf("unexpected %s", t))
+}
+
+func (p *parser) caseExpr() (expr, error) {
+	c := &caseExpr{}
+	for p.acceptKeyword("WHEN") {
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("THEN"); err != nil {
+			return nil, err
+		}
+		then, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		c.whens = append(c.whens, whenClause{cond, then})
+	}
+	if len(c.whens) == 0 {
+		t := p.peek()
+		return nil, syntaxError(t.pos, fmt.Sprintf("expected WHEN, got %s", t))
+	}
+	if p.acceptKeyword("ELSE") {
+		var err error
+		if c.els, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return c, p.expectKeyword("END")
+}
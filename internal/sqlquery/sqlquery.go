@@ -0,0 +1,342 @@
+// Package sqlquery runs read-only SQL queries over in-memory tables, such
+// as the file records of the metadata store:
+//
+//	SELECT tenant, COUNT(*), SUM(size) FROM objects GROUP BY tenant ORDER BY 3 DESC
+//
+// It understands a single SELECT with WHERE, GROUP BY, HAVING, ORDER BY,
+// LIMIT and OFFSET over one table, without joins or subqueries. Nothing a
+// query does can change the tables.
+package sqlquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultMaxRows bounds the rows a query returns
+const DefaultMaxRows = 10000
+
+// ErrInvalid is returned for queries that cannot be parsed or refer to
+// unknown tables, columns or functions
+var ErrInvalid = errors.New("sql: invalid query")
+
+func syntaxError(pos int, msg string) error {
+	return fmt.Errorf("%w: at offset %d: %s", ErrInvalid, pos, msg)
+}
+
+// Value is the value of a column: nil (NULL), int64, float64, string,
+// bool, time.Time, []string or map[string]string
+type Value = any
+
+// Table is a table queries can select from
+type Table struct {
+	Name string
+	// Columns name the values of each row, in order
+	Columns []string
+	Rows    [][]Value
+	// Doc describes the table and its columns to users
+	Doc string
+}
+
+// Options bounds a query
+type Options struct {
+	// MaxRows is the most rows returned; zero uses DefaultMaxRows
+	MaxRows int
+	// Now is the time NOW() returns; zero uses the current time
+	Now time.Time
+}
+
+// Result holds the rows of a query
+type Result struct {
+	Columns []string  `json:"columns"`
+	Rows    [][]Value `json:"rows"`
+	// Truncated is set when rows past MaxRows were dropped
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Query runs the SELECT statement src over tables
+func Query(ctx context.Context, src string, tables []Table, opts Options) (*Result, error) {
+	if opts.MaxRows <= 0 {
+		opts.MaxRows = DefaultMaxRows
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now().UTC()
+	}
+	stmt, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	idx := slices.IndexFunc(tables, func(t Table) bool { return strings.EqualFold(t.Name, stmt.from) })
+	if idx < 0 {
+		names := make([]string, len(tables))
+		for i, t := range tables {
+			names[i] = t.Name
+		}
+		return nil, fmt.Errorf("%w: no table %q, tables are %s", ErrInvalid, stmt.from, strings.Join(names, ", "))
+	}
+	q := &query{stmt: stmt, table: &tables[idx], now: opts.Now, cols: make(map[string]int)}
+	for i, c := range q.table.Columns {
+		q.cols[c] = i
+	}
+	res, err := q.run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Rows) > opts.MaxRows {
+		res.Rows = res.Rows[:opts.MaxRows]
+		res.Truncated = true
+	}
+	return res, nil
+}
+
+// query is a statement being run on a table
+type query struct {
+	stmt  *selectStmt
+	table *Table
+	cols  map[string]int
+	now   time.Time
+}
+
+// output is a row of the result with the values it is sorted by
+type output struct {
+	values []Value
+	keys   []Value
+}
+
+func (q *query) run(ctx context.Context) (*Result, error) {
+	stmt := q.stmt
+	if stmt.where != nil && hasAggregate(stmt.where) {
+		return nil, fmt.Errorf("%w: aggregates are not allowed in WHERE", ErrInvalid)
+	}
+	var rows [][]Value
+	for i, row := range q.table.Rows {
+		if i%1024 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if stmt.where != nil {
+			v, err := q.eval(stmt.where, &scope{row: row})
+			if err != nil {
+				return nil, err
+			}
+			if ok, _ := truth(v); !ok {
+				continue
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	grouped := len(stmt.groupBy) > 0 || stmt.having != nil
+	for _, item := range stmt.items {
+		grouped = grouped || hasAggregate(item.expr)
+	}
+	for _, item := range stmt.orderBy {
+		grouped = grouped || hasAggregate(item.expr)
+	}
+	if grouped && stmt.star {
+		return nil, fmt.Errorf("%w: SELECT * cannot be grouped", ErrInvalid)
+	}
+
+	res := &Result{Columns: q.columns()}
+	var scopes []*scope
+	if grouped {
+		groups, err := q.group(rows)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			s := &scope{group: g}
+			if len(g) > 0 {
+				s.row = g[0]
+			}
+			scopes = append(scopes, s)
+		}
+	} else {
+		for _, row := range rows {
+			scopes = append(scopes, &scope{row: row})
+		}
+	}
+
+	var outputs []output
+	seen := make(map[string]bool)
+	for _, s := range scopes {
+		out, keep, err := q.project(s)
+		if err != nil {
+			return nil, err
+		}
+		if !keep {
+			continue
+		}
+		if stmt.distinct {
+			k := key(out.values)
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+		}
+		outputs = append(outputs, out)
+	}
+
+	if len(stmt.orderBy) > 0 {
+		sort.SliceStable(outputs, func(i, j int) bool {
+			for k, item := range stmt.orderBy {
+				c := compare(outputs[i].keys[k], outputs[j].keys[k])
+				if c == 0 {
+					continue
+				}
+				if item.desc {
+					return c > 0
+				}
+				return c < 0
+			}
+			return false
+		})
+	}
+	if stmt.offset > 0 {
+		outputs = outputs[min(int(stmt.offset), len(outputs)):]
+	}
+	if stmt.limit >= 0 && int64(len(outputs)) > stmt.limit {
+		outputs = outputs[:stmt.limit]
+	}
+	res.Rows = make([][]Value, len(outputs))
+	for i, out := range outputs {
+		res.Rows[i] = out.values
+	}
+	return res, nil
+}
+
+func (q *query) columns() []string {
+	if q.stmt.star {
+		return slices.Clone(q.table.Columns)
+	}
+	names := make([]string, len(q.stmt.items))
+	for i, item := range q.stmt.items {
+		names[i] = item.name
+	}
+	return names
+}
+
+// group splits rows by the values of GROUP BY, in the order the groups
+// first appear. Without GROUP BY every row is in one group, which exists
+// even when there are no rows.
+func (q *query) group(rows [][]Value) ([][][]Value, error) {
+	if len(q.stmt.groupBy) == 0 {
+		return [][][]Value{rows}, nil
+	}
+	for _, e := range q.stmt.groupBy {
+		if hasAggregate(e) {
+			return nil, fmt.Errorf("%w: aggregates are not allowed in GROUP BY", ErrInvalid)
+		}
+	}
+	aliases := q.aliasExprs()
+	var groups [][][]Value
+	index := make(map[string]int)
+	for _, row := range rows {
+		values := make([]Value, len(q.stmt.groupBy))
+		for i, e := range q.stmt.groupBy {
+			v, err := q.eval(e, &scope{row: row, aliases: aliases})
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		k := key(values)
+		i, ok := index[k]
+		if !ok {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], row)
+	}
+	return groups, nil
+}
+
+// aliasExprs maps the names of the selected expressions to them, which
+// GROUP BY may refer to
+func (q *query) aliasExprs() map[string]Value {
+	aliases := make(map[string]Value)
+	for _, item := range q.stmt.items {
+		if _, isCol := q.cols[item.name]; !isCol && !hasAggregate(item.expr) {
+			aliases[item.name] = aliasExpr{item.expr}
+		}
+	}
+	return aliases
+}
+
+// aliasExpr is an alias standing for an expression evaluated where it is
+// referred to
+type aliasExpr struct{ expr expr }
+
+// project computes the selected values and sort keys of a row or group,
+// and whether HAVING keeps it
+func (q *query) project(s *scope) (output, bool, error) {
+	var out output
+	if q.stmt.star {
+		out.values = slices.Clone(s.row)
+	} else {
+		out.values = make([]Value, len(q.stmt.items))
+		for i, item := range q.stmt.items {
+			v, err := q.eval(item.expr, s)
+			if err != nil {
+				return out, false, err
+			}
+			out.values[i] = v
+		}
+	}
+	names := q.columns()
+	s.aliases = make(map[string]Value, len(names))
+	for i, name := range names {
+		if _, isCol := q.cols[name]; !isCol {
+			s.aliases[name] = out.values[i]
+		}
+	}
+
+	if q.stmt.having != nil {
+		v, err := q.eval(q.stmt.having, s)
+		if err != nil {
+			return out, false, err
+		}
+		if ok, _ := truth(v); !ok {
+			return out, false, nil
+		}
+	}
+	for _, item := range q.stmt.orderBy {
+		// ORDER BY 2 sorts by the second column, and ORDER BY name by the
+		// column of that name
+		if lit, ok := item.expr.(*literal); ok {
+			if n, ok := lit.v.(int64); ok {
+				if n < 1 || int(n) > len(out.values) {
+					return out, false, fmt.Errorf("%w: ORDER BY column %d out of range", ErrInvalid, n)
+				}
+				out.keys = append(out.keys, out.values[n-1])
+				continue
+			}
+		}
+		if col, ok := item.expr.(*columnRef); ok {
+			if i := slices.Index(names, col.name); i >= 0 {
+				out.keys = append(out.keys, out.values[i])
+				continue
+			}
+		}
+		v, err := q.eval(item.expr, s)
+		if err != nil {
+			return out, false, err
+		}
+		out.keys = append(out.keys, v)
+	}
+	return out, true, nil
+}
+
+// key encodes values for grouping and DISTINCT
+func key(values []Value) string {
+	var b strings.Builder
+	for _, v := range values {
+		fmt.Fprintf(&b, "%T:%v\x00", v, v)
+	}
+	return b.String()
+}
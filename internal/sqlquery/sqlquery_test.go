@@ -0,0 +1,169 @@
+package sqlquery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var day = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func testTables() []Table {
+	return MetadataTables([]metadata.FileRecord{
+		{Key: "acme/report.pdf", Size: 300, ContentType: "application/pdf", Owner: "alice", Metadata: map[string]string{"tenant": "acme", "year": "2023"}, Tags: []string{"finance"}, CreatedAt: day, UpdatedAt: day},
+		{Key: "acme/logo.png", Size: 100, ContentType: "image/png", Owner: "alice", Metadata: map[string]string{"tenant": "acme"}, CreatedAt: day.AddDate(0, 1, 0), UpdatedAt: day.AddDate(0, 1, 0),
+			Versions: []metadata.VersionRecord{{ID: "v1", Size: 90, CreatedAt: day}, {ID: "v0", Size: 80, StorageClass: metadata.StorageClassCold, CreatedAt: day}}},
+		{Key: "globex/data.csv", Size: 1000, ContentType: "text/csv", Owner: "bob", Tags: []string{"finance", "raw"}, CreatedAt: day.AddDate(0, 2, 0), UpdatedAt: day.AddDate(0, 2, 0)},
+		{Key: "tmp/scratch", Size: 5, CreatedAt: day, UpdatedAt: day},
+	})
+}
+
+func run(t *testing.T, sql string) *Result {
+	t.Helper()
+	res, err := Query(context.Background(), sql, testTables(), Options{Now: day.AddDate(1, 0, 0)})
+	require.NoError(t, err, sql)
+	return res
+}
+
+func TestQuery(t *testing.T) {
+	tests := []struct {
+		sql     string
+		columns []string
+		rows    [][]Value
+	}{
+		{
+			sql:     "SELECT tenant, SUM(size) FROM objects GROUP BY tenant",
+			columns: []string{"tenant", "SUM(size)"},
+			rows:    [][]Value{{"acme", int64(400)}, {"bob", int64(1000)}, {nil, int64(5)}},
+		},
+		{
+			sql:     "select tenant, count(*) as files, sum(size) bytes from objects where tenant is not null group by tenant having sum(size) > 500 order by files desc",
+			columns: []string{"tenant", "files", "bytes"},
+			rows:    [][]Value{{"bob", int64(1), int64(1000)}},
+		},
+		{
+			sql:     "SELECT key FROM objects WHERE key LIKE 'ACME/%' AND size BETWEEN 50 AND 200",
+			columns: []string{"key"},
+			rows:    [][]Value{{"acme/logo.png"}},
+		},
+		{
+			sql:     "SELECT key, size FROM objects ORDER BY size DESC LIMIT 2 OFFSET 1",
+			columns: []string{"key", "size"},
+			rows:    [][]Value{{"acme/report.pdf", int64(300)}, {"acme/logo.png", int64(100)}},
+		},
+		{
+			sql:     "SELECT split_part(key, '/', 1) AS top, COUNT(*) FROM objects GROUP BY top ORDER BY top",
+			columns: []string{"top", "COUNT(*)"},
+			rows:    [][]Value{{"acme", int64(2)}, {"globex", int64(1)}, {"tmp", int64(1)}},
+		},
+		{
+			sql:     "SELECT key, meta('year') FROM objects WHERE has_tag('finance') AND meta('year') IS NULL",
+			columns: []string{"key", "meta('year')"},
+			rows:    [][]Value{{"globex/data.csv", nil}},
+		},
+		{
+			sql:     "SELECT date(created_at) d, COUNT(*) FROM objects WHERE created_at >= '2024-04-01' GROUP BY d ORDER BY d",
+			columns: []string{"d", "COUNT(*)"},
+			rows:    [][]Value{{"2024-04-01", int64(1)}, {"2024-05-01", int64(1)}},
+		},
+		{
+			sql:     "SELECT COUNT(*), COUNT(content_type), COUNT(DISTINCT owner), MIN(size), MAX(key), AVG(size) FROM objects",
+			columns: []string{"COUNT(*)", "COUNT(content_type)", "COUNT(DISTINCT owner)", "MIN(size)", "MAX(key)", "AVG(size)"},
+			rows:    [][]Value{{int64(4), int64(3), int64(2), int64(5), "tmp/scratch", 351.25}},
+		},
+		{
+			// Aggregates over no rows give one row
+			sql:     "SELECT COUNT(*), SUM(size), TOTAL(size) FROM objects WHERE size < 0",
+			columns: []string{"COUNT(*)", "SUM(size)", "TOTAL(size)"},
+			rows:    [][]Value{{int64(0), nil, float64(0)}},
+		},
+		{
+			sql:     "SELECT DISTINCT owner FROM objects WHERE owner IN ('alice', 'bob') ORDER BY 1",
+			columns: []string{"owner"},
+			rows:    [][]Value{{"alice"}, {"bob"}},
+		},
+		{
+			sql:     "SELECT key, CASE WHEN size >= 1000 THEN 'large' WHEN size >= 100 THEN 'medium' ELSE 'small' END AS class, size / 3, size * 1.5, -size % 7 FROM objects WHERE NOT key LIKE 'acme%' ORDER BY key",
+			columns: []string{"key", "class", "size / 3", "size * 1.5", "-size % 7"},
+			rows:    [][]Value{{"globex/data.csv", "large", int64(333), 1500.0, int64(-6)}, {"tmp/scratch", "small", int64(1), 7.5, int64(-5)}},
+		},
+		{
+			sql:     "SELECT key, version_id, storage_class FROM versions WHERE tenant = 'acme' ORDER BY version_id",
+			columns: []string{"key", "version_id", "storage_class"},
+			rows:    [][]Value{{"acme/logo.png", "v0", "cold"}, {"acme/logo.png", "v1", "hot"}},
+		},
+		{
+			sql:     "SELECT upper(tenant) || ':' || lower('X'), length(tags), substr(key, 1, 4), coalesce(content_type, 'none'), round(size / 7.0, 2), abs(-size) FROM objects WHERE key = 'tmp/scratch'",
+			columns: []string{"upper(tenant) || ':' || lower('X')", "length(tags)", "substr(key, 1, 4)", "coalesce(content_type, 'none')", "round(size / 7.0, 2)", "abs(-size)"},
+			rows:    [][]Value{{nil, int64(0), "tmp/", "none", 0.71, int64(5)}},
+		},
+		{
+			sql:     "SELECT COUNT(*) FROM objects WHERE updated_at < now() AND (size > 1000 OR NULL)",
+			columns: []string{"COUNT(*)"},
+			rows:    [][]Value{{int64(0)}},
+		},
+	}
+	for _, tt := range tests {
+		res := run(t, tt.sql)
+		assert.Equal(t, tt.columns, res.Columns, tt.sql)
+		assert.Equal(t, tt.rows, res.Rows, tt.sql)
+	}
+}
+
+func TestQueryStarAndLimits(t *testing.T) {
+	res := run(t, "SELECT * FROM objects WHERE key = 'globex/data.csv';")
+	require.Len(t, res.Rows, 1)
+	assert.Equal(t, "key", res.Columns[0])
+	assert.Equal(t, []string{"finance", "raw"}, res.Rows[0][7])
+
+	res, err := Query(context.Background(), "SELECT key FROM objects", testTables(), Options{MaxRows: 3})
+	require.NoError(t, err)
+	assert.Len(t, res.Rows, 3)
+	assert.True(t, res.Truncated)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Query(ctx, "SELECT key FROM objects", testTables(), Options{})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestQueryErrors(t *testing.T) {
+	tests := []struct {
+		sql string
+		err string
+	}{
+		{"DELETE FROM objects", "only SELECT queries"},
+		{"DROP TABLE objects", "only SELECT queries"},
+		{"SELECT key FROM objects; DELETE FROM objects", "unexpected \"DELETE\""},
+		{"SELECT key FROM files", `no table "files", tables are objects, versions`},
+		{"SELECT nope FROM objects", `no column "nope"`},
+		{"SELECT key FROM objects WHERE COUNT(*) > 1", "aggregates are not allowed in WHERE"},
+		{"SELECT * FROM objects GROUP BY tenant", "SELECT * cannot be grouped"},
+		{"SELECT SUM(key) FROM objects", "cannot SUM text values"},
+		{"SELECT key + 1 FROM objects", "cannot compute text + integer"},
+		{"SELECT frobnicate(key) FROM objects", "no function FROBNICATE"},
+		{"SELECT key FROM objects ORDER BY 3", "ORDER BY column 3 out of range"},
+		{"SELECT key FROM objects WHERE key = 'open", "unterminated string"},
+		{"SELECT key FROM objects LIMIT -1", "expected a non-negative integer"},
+		{"SELECT FROM objects", "unexpected \"FROM\""},
+		{"SELECT key FROM objects WHERE key NOT = 'a'", "expected LIKE, IN or BETWEEN after NOT"},
+	}
+	for _, tt := range tests {
+		_, err := Query(context.Background(), tt.sql, testTables(), Options{})
+		assert.ErrorIs(t, err, ErrInvalid, tt.sql)
+		assert.ErrorContains(t, err, tt.err, tt.sql)
+	}
+}
+
+func TestLike(t *testing.T) {
+	assert.True(t, like("report.pdf", "%.PDF"))
+	assert.True(t, like("report.pdf", "r_port%"))
+	assert.True(t, like("abcabc", "%abc"))
+	assert.True(t, like("", "%"))
+	assert.False(t, like("report.pdf", "%.png"))
+	assert.False(t, like("ab", "a_b"))
+}
@@ -0,0 +1,360 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Skpow1234/Peervault/pkg/merkle"
+)
+
+// FormatVersion identifies the on-disk layout used by a store
+type FormatVersion int
+
+const (
+	// FormatCAS stores each object as a single file at its transformed path
+	FormatCAS FormatVersion = 1
+	// FormatChunked stores each object as content-addressed chunks plus a
+	// manifest carrying the merkle root of the chunk hashes
+	FormatChunked FormatVersion = 2
+)
+
+const (
+	// DefaultChunkSize is the chunk size used by the chunked layout
+	DefaultChunkSize = 1 << 20
+	// IdentityFileName is the node identity key kept at the root of the
+	// store, next to the objects
+	IdentityFileName = "identity.key"
+
+	formatFileName   = "FORMAT"
+	chunkedDirName   = ".v2"
+	manifestFileName = "manifest.json"
+	tmpPrefix        = ".tmp-"
+)
+
+func (v FormatVersion) String() string {
+	switch v {
+	case FormatCAS:
+		return "cas"
+	case FormatChunked:
+		return "chunked"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(v))
+	}
+}
+
+// ChunkRef describes a single chunk of an object
+type ChunkRef struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// Manifest describes an object stored in the chunked layout
+type Manifest struct {
+	Version    FormatVersion `json:"version"`
+	Path       string        `json:"path"`
+	Size       int64         `json:"size"`
+	ChunkSize  int           `json:"chunk_size"`
+	Chunks     []ChunkRef    `json:"chunks"`
+	MerkleRoot string        `json:"merkle_root"`
+	CreatedAt  time.Time     `json:"created_at"`
+	// Hash is the algorithm of the chunk hashes and merkle root; empty for
+	// manifests written before it was recorded, which used SHA-256
+	Hash HashAlgorithm `json:"hash,omitempty"`
+}
+
+// hash returns the algorithm the chunks of the manifest are hashed with
+func (m *Manifest) hash() HashAlgorithm {
+	if m.Hash == "" {
+		return HashSHA256
+	}
+	return m.Hash
+}
+
+type formatFile struct {
+	Version   FormatVersion `json:"version"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// Format returns the layout new objects are written in
+func (s *Store) Format() FormatVersion { return FormatVersion(s.format.Load()) }
+
+func (s *Store) loadFormat() FormatVersion {
+	data, err := os.ReadFile(filepath.Join(s.Root, formatFileName))
+	if err != nil {
+		// Stores without a format file predate versioning
+		return FormatCAS
+	}
+	var ff formatFile
+	if err := json.Unmarshal(data, &ff); err != nil || ff.Version == 0 {
+		return FormatCAS
+	}
+	return ff.Version
+}
+
+// RecordFormat writes the format file of a store predating it, which is
+// read as FormatCAS, so later releases need not guess the format; with
+// dryRun it only reports whether it would. It is a step of the layout
+// upgrades run on startup.
+func (s *Store) RecordFormat(dryRun bool) ([]string, error) {
+	_, err := os.Stat(filepath.Join(s.Root, formatFileName))
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	change := fmt.Sprintf("record the %s format in %s", FormatCAS, formatFileName)
+	if dryRun {
+		return []string{change}, nil
+	}
+	if err := s.setFormat(FormatCAS); err != nil {
+		return nil, err
+	}
+	return []string{change}, nil
+}
+
+// StateFiles returns the files recording the format and hashing of the
+// store, to back up before they are upgraded
+func (s *Store) StateFiles() []string {
+	return []string{filepath.Join(s.Root, formatFileName), filepath.Join(s.Root, hashFileName)}
+}
+
+// setFormat persists the format version and switches the write path to it
+func (s *Store) setFormat(v FormatVersion) error {
+	if err := os.MkdirAll(s.Root, os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.Marshal(formatFile{Version: v, UpdatedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(s.Root, formatFileName), data); err != nil {
+		return err
+	}
+	s.format.Store(int32(v))
+	return nil
+}
+
+// objectDir returns the chunked layout directory of an object
+func (s *Store) objectDir(fullPath string) string {
+	sum := sha256.Sum256([]byte(fullPath))
+	return filepath.Join(s.Root, chunkedDirName, hex.EncodeToString(sum[:]))
+}
+
+func (s *Store) legacyPath(fullPath string) string {
+	return fmt.Sprintf("%s/%s", s.Root, fullPath)
+}
+
+func (s *Store) readManifest(fullPath string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(s.objectDir(fullPath), manifestFileName))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("corrupt manifest for %s: %w", fullPath, err)
+	}
+	return &m, nil
+}
+
+func (s *Store) hasChunked(fullPath string) bool {
+	_, err := os.Stat(filepath.Join(s.objectDir(fullPath), manifestFileName))
+	return err == nil
+}
+
+// chunkWriter splits a byte stream into chunk files inside a staging directory
+type chunkWriter struct {
+	dir       string
+	chunkSize int
+	hash      HashAlgorithm
+	buf       []byte
+	chunks    []ChunkRef
+	size      int64
+}
+
+func newChunkWriter(dir string, chunkSize int, hash HashAlgorithm) *chunkWriter {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return &chunkWriter{dir: dir, chunkSize: chunkSize, hash: hash.chunkHash(), buf: make([]byte, 0, chunkSize)}
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(w.chunkSize-len(w.buf), len(p))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(w.buf) == w.chunkSize {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *chunkWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	hash := hex.EncodeToString(w.hash.Sum(w.buf))
+	path := filepath.Join(w.dir, hash)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(path, w.buf, 0644); err != nil {
+			return err
+		}
+	}
+	w.chunks = append(w.chunks, ChunkRef{Hash: hash, Size: int64(len(w.buf))})
+	w.size += int64(len(w.buf))
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// finish flushes the trailing chunk and writes the manifest
+func (w *chunkWriter) finish(fullPath string) (*Manifest, error) {
+	if err := w.flush(); err != nil {
+		return nil, err
+	}
+	hashes := make([][]byte, len(w.chunks))
+	for i, c := range w.chunks {
+		h, err := hex.DecodeString(c.Hash)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = h
+	}
+	m := &Manifest{
+		Version:    FormatChunked,
+		Path:       fullPath,
+		Size:       w.size,
+		ChunkSize:  w.chunkSize,
+		Chunks:     w.chunks,
+		MerkleRoot: hex.EncodeToString(merkleRoot(w.hash, hashes)),
+		CreatedAt:  time.Now(),
+		Hash:       w.hash,
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(w.dir, manifestFileName), data, 0644); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// stageDir creates a private staging directory next to the chunked objects
+// so the finished object can be moved into place with a single rename.
+// Callers release it with dropStage; until then GC leaves it alone.
+func (s *Store) stageDir() (string, error) {
+	base := filepath.Join(s.Root, chunkedDirName)
+	if err := os.MkdirAll(base, os.ModePerm); err != nil {
+		return "", err
+	}
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return "", err
+	}
+	dir := filepath.Join(base, tmpPrefix+hex.EncodeToString(suffix[:]))
+	s.staging.Store(dir, struct{}{})
+	if err := os.Mkdir(dir, os.ModePerm); err != nil {
+		s.staging.Delete(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// dropStage removes a staging directory, if it was not moved into place
+func (s *Store) dropStage(dir string) {
+	_ = os.RemoveAll(dir)
+	s.staging.Delete(dir)
+}
+
+// writeChunked writes an object in the chunked layout. Like the CAS layout it
+// refuses to overwrite an existing object.
+func (s *Store) writeChunked(fullPath string, fill func(io.Writer) (int64, error)) (int64, error) {
+	stage, err := s.stageDir()
+	if err != nil {
+		return 0, err
+	}
+	defer s.dropStage(stage)
+
+	w := newChunkWriter(stage, s.ChunkSize, s.Hash())
+	n, err := fill(w)
+	if err != nil {
+		return n, err
+	}
+	if _, err := w.finish(fullPath); err != nil {
+		return n, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hasChunked(fullPath) {
+		return 0, fmt.Errorf("file %s already exists", fullPath)
+	}
+	if err := os.Rename(stage, s.objectDir(fullPath)); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// chunkReader streams an object back from its chunks, verifying each chunk
+// against the manifest as it is read
+type chunkReader struct {
+	dir    string
+	hash   HashAlgorithm
+	chunks []ChunkRef
+	cur    *bytes.Reader
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for r.cur == nil || r.cur.Len() == 0 {
+		if len(r.chunks) == 0 {
+			return 0, io.EOF
+		}
+		ref := r.chunks[0]
+		r.chunks = r.chunks[1:]
+		data, err := os.ReadFile(filepath.Join(r.dir, ref.Hash))
+		if err != nil {
+			return 0, err
+		}
+		if hex.EncodeToString(r.hash.Sum(data)) != ref.Hash {
+			return 0, fmt.Errorf("chunk %s failed verification", ref.Hash)
+		}
+		r.cur = bytes.NewReader(data)
+	}
+	return r.cur.Read(p)
+}
+
+func (r *chunkReader) Close() error { return nil }
+
+func (s *Store) readChunked(fullPath string) (int64, io.ReadCloser, error) {
+	m, err := s.readManifest(fullPath)
+	if err != nil {
+		return 0, nil, err
+	}
+	return m.Size, &chunkReader{dir: s.objectDir(fullPath), hash: m.hash(), chunks: m.Chunks}, nil
+}
+
+// merkleRoot computes a binary merkle root over the chunk hashes, promoting
+// the last node of odd-sized levels unchanged
+func merkleRoot(alg HashAlgorithm, hashes [][]byte) []byte {
+	return merkle.Root(alg.New, hashes)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
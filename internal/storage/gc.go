@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GCReport counts what a garbage collection removed
+type GCReport struct {
+	// Staging counts staging directories left behind by interrupted writes
+	Staging int `json:"staging"`
+	// Objects counts chunked object directories without a manifest
+	Objects int `json:"objects"`
+	// Chunks counts chunks no manifest references
+	Chunks int   `json:"chunks"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// GC removes what no object references from the chunked layout: staging
+// directories not in use, object directories without a manifest and
+// unreferenced chunks. Objects whose manifest cannot be read are left for
+// Check to report.
+func (s *Store) GC() (*GCReport, error) {
+	report, err := s.gc()
+	if err == nil && s.OnViolation != nil {
+		if check, err := s.Check(); err == nil && len(check.Violations) > 0 {
+			s.OnViolation("gc", check.Violations)
+		}
+	}
+	return report, err
+}
+
+func (s *Store) gc() (*GCReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := &GCReport{}
+	base := filepath.Join(s.Root, chunkedDirName)
+	entries, err := os.ReadDir(base)
+	if errors.Is(err, os.ErrNotExist) {
+		return report, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(base, e.Name())
+		if strings.HasPrefix(e.Name(), tmpPrefix) {
+			if _, ok := s.staging.Load(dir); ok {
+				continue
+			}
+			size, err := removeAll(dir)
+			if err != nil {
+				return report, err
+			}
+			report.Staging++
+			report.Bytes += size
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, manifestFileName))
+		if errors.Is(err, fs.ErrNotExist) {
+			size, err := removeAll(dir)
+			if err != nil {
+				return report, err
+			}
+			report.Objects++
+			report.Bytes += size
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		var m Manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			continue
+		}
+		refs := make(map[string]bool, len(m.Chunks))
+		for _, c := range m.Chunks {
+			refs[c.Hash] = true
+		}
+		chunks, err := os.ReadDir(dir)
+		if err != nil {
+			return report, err
+		}
+		for _, c := range chunks {
+			if c.Name() == manifestFileName || refs[c.Name()] {
+				continue
+			}
+			size, err := removeAll(filepath.Join(dir, c.Name()))
+			if err != nil {
+				return report, err
+			}
+			report.Chunks++
+			report.Bytes += size
+		}
+	}
+	return report, nil
+}
+
+// removeAll removes a file or directory tree, returning the bytes it held
+func removeAll(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	return size, os.RemoveAll(path)
+}
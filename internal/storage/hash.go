@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"lukechampine.com/blake3"
+)
+
+// HashAlgorithm is the hash naming the CAS path of a key and the chunks of
+// an object
+type HashAlgorithm string
+
+const (
+	// HashSHA1 names CAS paths the way stores always have; chunks of such
+	// stores are hashed with SHA-256
+	HashSHA1 HashAlgorithm = "sha1"
+	// HashSHA256 hashes paths and chunks with SHA-256
+	HashSHA256 HashAlgorithm = "sha256"
+	// HashBLAKE3 hashes paths and chunks with BLAKE3, several times faster
+	// than SHA-256 on large chunks
+	HashBLAKE3 HashAlgorithm = "blake3"
+)
+
+const hashFileName = "HASH"
+
+// ParseHashAlgorithm parses the name of a hash algorithm
+func ParseHashAlgorithm(name string) (HashAlgorithm, error) {
+	switch h := HashAlgorithm(strings.ToLower(strings.TrimSpace(name))); h {
+	case HashSHA1, HashSHA256, HashBLAKE3:
+		return h, nil
+	default:
+		return "", fmt.Errorf("storage: unknown hash algorithm %q (want sha1, sha256 or blake3)", name)
+	}
+}
+
+// New returns a hash.Hash computing the algorithm
+func (h HashAlgorithm) New() hash.Hash {
+	switch h {
+	case HashSHA1:
+		return sha1.New()
+	case HashBLAKE3:
+		return blake3.New(32, nil)
+	default:
+		return sha256.New()
+	}
+}
+
+// Sum returns the hash of data
+func (h HashAlgorithm) Sum(data []byte) []byte {
+	switch h {
+	case HashSHA1:
+		sum := sha1.Sum(data)
+		return sum[:]
+	case HashBLAKE3:
+		sum := blake3.Sum256(data)
+		return sum[:]
+	default:
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}
+}
+
+// chunkHash is the algorithm chunks are hashed with; chunks have always
+// been hashed with SHA-256 on stores naming paths with SHA-1
+func (h HashAlgorithm) chunkHash() HashAlgorithm {
+	if h == HashBLAKE3 {
+		return HashBLAKE3
+	}
+	return HashSHA256
+}
+
+// CASPathTransform returns a PathTransformFunc splitting the hash of a key
+// into directories of five characters
+func CASPathTransform(h HashAlgorithm) PathTransformFunc {
+	return func(key string) PathKey {
+		hashStr := hex.EncodeToString(h.Sum([]byte(key)))
+		blocksize := 5
+		sliceLen := len(hashStr) / blocksize
+		paths := make([]string, sliceLen)
+		for i := 0; i < sliceLen; i++ {
+			from, to := i*blocksize, (i*blocksize)+blocksize
+			paths[i] = hashStr[from:to]
+		}
+		return PathKey{PathName: strings.Join(paths, "/"), Filename: hashStr}
+	}
+}
+
+// hashFile records the algorithm naming the paths of a store and the ones
+// it named them with before, which lookups fall back to
+type hashFile struct {
+	Current   HashAlgorithm   `json:"current"`
+	Previous  []HashAlgorithm `json:"previous,omitempty"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// HashStatus describes the hashing of a store
+type HashStatus struct {
+	Current HashAlgorithm `json:"current"`
+	// Previous are the algorithms objects may still be found under, most
+	// recent first; they move to the current one as they are read
+	Previous []HashAlgorithm `json:"previous,omitempty"`
+	// Moved counts the objects moved to the current algorithm since start
+	Moved int64 `json:"moved"`
+}
+
+// initHash switches the store to CAS paths named with h. Switching from
+// another algorithm keeps it as a previous one, so objects written before
+// are still found.
+func (s *Store) initHash(h HashAlgorithm) {
+	s.PathTransformFunc = CASPathTransform(h)
+	hf := hashFile{Current: HashSHA1}
+	if data, err := os.ReadFile(filepath.Join(s.Root, hashFileName)); err == nil {
+		if err := json.Unmarshal(data, &hf); err != nil {
+			slog.Warn("ignoring corrupt hash file", "error", err)
+			hf = hashFile{Current: HashSHA1}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("failed to read hash file", "error", err)
+	} else if entries, _ := os.ReadDir(s.Root); len(entries) == 0 {
+		// A new store has nothing to fall back to
+		hf.Current = h
+	}
+
+	if hf.Current != h {
+		hf.Previous = slices.DeleteFunc(slices.Insert(hf.Previous, 0, hf.Current), func(p HashAlgorithm) bool { return p == h })
+		hf.Previous = slices.Compact(hf.Previous)
+		hf.Current = h
+		hf.UpdatedAt = time.Now()
+		slog.Info("storage hash changed", "hash", h, "previous", hf.Previous)
+	}
+	if hf.UpdatedAt.IsZero() {
+		hf.UpdatedAt = time.Now()
+	}
+	if err := s.saveHashFile(hf); err != nil {
+		slog.Warn("failed to save hash file", "error", err)
+	}
+
+	s.hash = h
+	for _, p := range hf.Previous {
+		s.previous = append(s.previous, previousHash{alg: p, transform: CASPathTransform(p)})
+	}
+}
+
+func (s *Store) saveHashFile(hf hashFile) error {
+	if err := os.MkdirAll(s.Root, os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.Marshal(hf)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.Root, hashFileName), data)
+}
+
+// Hash returns the algorithm chunks of new objects are hashed with, and
+// for stores with hashed CAS paths the algorithm naming them
+func (s *Store) Hash() HashAlgorithm {
+	if s.hash == "" {
+		return HashSHA1
+	}
+	return s.hash
+}
+
+// HashStatus returns the hashing of the store
+func (s *Store) HashStatus() HashStatus {
+	status := HashStatus{Current: s.Hash(), Moved: s.moved.Load()}
+	for _, p := range s.previous {
+		status.Previous = append(status.Previous, p.alg)
+	}
+	return status
+}
+
+type previousHash struct {
+	alg       HashAlgorithm
+	transform PathTransformFunc
+}
+
+// locate returns the full path key is stored under: its path under the
+// current algorithm, or else the first previous one holding it
+func (s *Store) locate(key string) string {
+	fullPath := s.PathTransformFunc(key).FullPath()
+	if len(s.previous) == 0 || s.exists(fullPath) {
+		return fullPath
+	}
+	for _, p := range s.previous {
+		if old := p.transform(key).FullPath(); s.exists(old) {
+			return old
+		}
+	}
+	return fullPath
+}
+
+func (s *Store) exists(fullPath string) bool {
+	if _, err := os.Stat(s.legacyPath(fullPath)); err == nil {
+		return true
+	}
+	return s.hasChunked(fullPath)
+}
+
+// Rehash moves key from a path of a previous algorithm to its path under
+// the current one, reporting whether it moved. Reads do so on their own.
+func (s *Store) Rehash(key string) (bool, error) {
+	fullPath := s.PathTransformFunc(key).FullPath()
+	old := s.locate(key)
+	if old == fullPath {
+		return false, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.exists(fullPath) {
+		return false, nil
+	}
+	if _, err := os.Stat(s.legacyPath(old)); err == nil {
+		if err := os.MkdirAll(filepath.Dir(s.legacyPath(fullPath)), os.ModePerm); err != nil {
+			return false, err
+		}
+		if err := os.Rename(s.legacyPath(old), s.legacyPath(fullPath)); err != nil {
+			return false, err
+		}
+		s.pruneDirs(old)
+	} else if err := s.moveChunked(old, fullPath); err != nil {
+		return false, err
+	}
+	s.moved.Add(1)
+	return true, nil
+}
+
+// moveChunked renames the chunked object at from to to; callers hold s.mu
+func (s *Store) moveChunked(from, to string) error {
+	m, err := s.readManifest(from)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(s.objectDir(from), s.objectDir(to)); err != nil {
+		return err
+	}
+	m.Path = to
+	data, err := json.Marshal(m)
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(s.objectDir(to), manifestFileName), data)
+	}
+	if err != nil {
+		_ = os.Rename(s.objectDir(to), s.objectDir(from))
+		return err
+	}
+	return nil
+}
+
+// pruneDirs removes the directories of a CAS path left empty; callers hold
+// s.mu
+func (s *Store) pruneDirs(fullPath string) {
+	dir := filepath.Dir(fullPath)
+	for dir != "." && dir != "/" && !strings.HasPrefix(dir, "..") {
+		if err := os.Remove(filepath.Join(s.Root, dir)); err != nil {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+}
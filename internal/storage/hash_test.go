@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHashAlgorithm(t *testing.T) {
+	for _, name := range []string{"sha1", "SHA256", " blake3 "} {
+		_, err := ParseHashAlgorithm(name)
+		assert.NoError(t, err, name)
+	}
+	_, err := ParseHashAlgorithm("md5")
+	assert.Error(t, err)
+
+	assert.Equal(t, CASPathTransformFunc("key"), CASPathTransform(HashSHA1)("key"))
+	for _, h := range []HashAlgorithm{HashSHA256, HashBLAKE3} {
+		pathKey := CASPathTransform(h)("key")
+		assert.Len(t, pathKey.Filename, 64, h)
+		assert.Len(t, h.Sum([]byte("key")), 32, h)
+	}
+	assert.NotEqual(t, CASPathTransform(HashSHA256)("key"), CASPathTransform(HashBLAKE3)("key"))
+}
+
+func TestStore_SwitchHash(t *testing.T) {
+	root := t.TempDir()
+	open := func(h HashAlgorithm) *Store {
+		return NewStore(StoreOpts{Root: root, Hash: h, ChunkSize: 16})
+	}
+
+	s := open(HashSHA1)
+	assert.Equal(t, HashStatus{Current: HashSHA1}, s.HashStatus())
+	_, err := s.Write("legacy", bytes.NewReader([]byte("legacy object")))
+	require.NoError(t, err)
+	require.NoError(t, s.setFormat(FormatChunked))
+	_, err = s.Write("chunked", bytes.NewReader([]byte("an object spanning chunks")))
+	require.NoError(t, err)
+	_, err = s.Write("untouched", bytes.NewReader([]byte("moved by Rehash")))
+	require.NoError(t, err)
+	_, err = s.Write("deleted", bytes.NewReader([]byte("gone")))
+	require.NoError(t, err)
+
+	// Objects written under SHA-1 paths are still found after the switch
+	s = open(HashBLAKE3)
+	assert.Equal(t, []HashAlgorithm{HashSHA1}, s.HashStatus().Previous)
+	for _, key := range []string{"legacy", "chunked", "untouched", "deleted"} {
+		assert.True(t, s.Has(key), key)
+	}
+	assert.Equal(t, []byte("legacy object"), readAll(t, s, "legacy"))
+	assert.Equal(t, []byte("an object spanning chunks"), readAll(t, s, "chunked"))
+	assert.Equal(t, int64(2), s.HashStatus().Moved)
+	assert.True(t, s.exists(CASPathTransform(HashBLAKE3)("legacy").FullPath()))
+	assert.False(t, s.exists(CASPathTransformFunc("chunked").FullPath()))
+
+	moved, err := s.Rehash("untouched")
+	require.NoError(t, err)
+	assert.True(t, moved)
+	moved, err = s.Rehash("untouched")
+	require.NoError(t, err)
+	assert.False(t, moved)
+
+	require.NoError(t, s.Delete("deleted"))
+	assert.False(t, s.Has("deleted"))
+
+	// New chunks are hashed with BLAKE3; old manifests still verify
+	_, err = s.Write("new", bytes.NewReader([]byte("written after the switch")))
+	require.NoError(t, err)
+	m, err := s.readManifest(s.PathTransformFunc("new").FullPath())
+	require.NoError(t, err)
+	assert.Equal(t, HashBLAKE3, m.Hash)
+	m, err = s.readManifest(s.PathTransformFunc("chunked").FullPath())
+	require.NoError(t, err)
+	assert.Equal(t, HashSHA256, m.hash())
+	assert.Equal(t, s.PathTransformFunc("chunked").FullPath(), m.Path)
+
+	// Reopening keeps the history
+	s = open(HashBLAKE3)
+	assert.Equal(t, []HashAlgorithm{HashSHA1}, s.HashStatus().Previous)
+	assert.Equal(t, []byte("moved by Rehash"), readAll(t, s, "untouched"))
+	s = open(HashSHA256)
+	assert.Equal(t, []HashAlgorithm{HashBLAKE3, HashSHA1}, s.HashStatus().Previous)
+	assert.Equal(t, []byte("written after the switch"), readAll(t, s, "new"))
+}
+
+func TestStore_NewStoreHasNoPreviousHash(t *testing.T) {
+	s := NewStore(StoreOpts{Root: t.TempDir(), Hash: HashBLAKE3})
+	assert.Equal(t, HashStatus{Current: HashBLAKE3}, s.HashStatus())
+}
+
+func BenchmarkChunkHash(b *testing.B) {
+	data := make([]byte, DefaultChunkSize)
+	_, _ = rand.Read(data)
+	for _, h := range []HashAlgorithm{HashSHA256, HashBLAKE3} {
+		b.Run(string(h), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				h.Sum(data)
+			}
+		})
+	}
+}
+
+func BenchmarkWriteChunked(b *testing.B) {
+	data := make([]byte, 16*DefaultChunkSize)
+	_, _ = rand.Read(data)
+	for _, h := range []HashAlgorithm{HashSHA256, HashBLAKE3} {
+		b.Run(string(h), func(b *testing.B) {
+			s := NewStore(StoreOpts{Root: b.TempDir(), Hash: h})
+			require.NoError(b, s.setFormat(FormatChunked))
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.Write(fmt.Sprintf("object-%d", i), bytes.NewReader(data)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Invariants a consistent store holds
+const (
+	// InvariantRefcounts: every chunk an object's manifest references is
+	// stored, and every chunk stored is referenced. A chunk referenced but
+	// missing would have a negative count once its references are dropped.
+	InvariantRefcounts = "refcounts"
+	// InvariantCASPaths: chunks are named by the hash of their content,
+	// objects sit in the directory their path hashes to, the merkle root
+	// matches the chunks, and hashed CAS files sit under the directories
+	// their name splits into
+	InvariantCASPaths = "cas-paths"
+	// InvariantManifestCoverage: the chunks of a manifest cover the object
+	// exactly, each a full chunk but the last
+	InvariantManifestCoverage = "manifest-coverage"
+)
+
+// Problems the violations are classified as, which decide how they are
+// repaired
+const (
+	// ProblemOrphan is data no object references, which GC removes
+	ProblemOrphan = "orphan"
+	// ProblemTruncated is a chunk shorter than its manifest says
+	ProblemTruncated = "truncated"
+	// ProblemDangling is a manifest referencing chunks that are not stored
+	ProblemDangling = "dangling-manifest"
+	// ProblemCorrupt is content or a manifest disagreeing with its hashes
+	// or with itself
+	ProblemCorrupt = "corrupt"
+	// ProblemMisplaced is an object away from the path its name hashes to
+	ProblemMisplaced = "misplaced"
+)
+
+// Violation is an invariant found broken
+type Violation struct {
+	Invariant string `json:"invariant"`
+	Problem   string `json:"problem"`
+	// Path is the object's full path, or for objects whose manifest cannot
+	// be read the directory holding them, relative to the root
+	Path string `json:"path"`
+	// Location is the file or chunked object directory holding the object,
+	// relative to the root, as Quarantine takes it
+	Location string `json:"location"`
+	Detail   string `json:"detail"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s: %s", v.Invariant, v.Path, v.Detail)
+}
+
+// CheckReport is the result of checking a store
+type CheckReport struct {
+	Format     string      `json:"format"`
+	Objects    int         `json:"objects"`
+	Chunks     int         `json:"chunks"`
+	Violations []Violation `json:"violations"`
+}
+
+// Check verifies the invariants over every object of the store, reading
+// every chunk. It holds the store lock, so objects are neither moved nor
+// deleted while they are checked; CAS files being written are skipped.
+func (s *Store) Check() (*CheckReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := &CheckReport{Format: s.Format().String(), Violations: []Violation{}}
+	paths, err := s.legacyObjects()
+	if err != nil {
+		return nil, err
+	}
+	for _, fullPath := range paths {
+		if s.writing(fullPath) {
+			continue
+		}
+		report.Objects++
+		report.Violations = append(report.Violations, checkLegacyPath(fullPath)...)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.Root, chunkedDirName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), tmpPrefix) {
+			continue
+		}
+		chunks, violations, err := s.checkObjectDir(e.Name())
+		if err != nil {
+			return nil, err
+		}
+		report.Objects++
+		report.Chunks += chunks
+		report.Violations = append(report.Violations, violations...)
+	}
+	return report, nil
+}
+
+// checkObject verifies the invariants over the object at fullPath in
+// either layout, if it exists
+func (s *Store) checkObject(fullPath string) ([]Violation, error) {
+	var violations []Violation
+	if _, err := os.Stat(s.legacyPath(fullPath)); err == nil {
+		violations = append(violations, checkLegacyPath(fullPath)...)
+	}
+	dir := s.objectDir(fullPath)
+	if _, err := os.Stat(dir); err != nil {
+		return violations, nil
+	}
+	_, found, err := s.checkObjectDir(filepath.Base(dir))
+	return append(violations, found...), err
+}
+
+// checkLegacyPath verifies a CAS file that looks hashed, with a hex name
+// under directories of five hex characters, is under the directories its
+// name splits into
+func checkLegacyPath(fullPath string) []Violation {
+	dir, name := filepath.Split(fullPath)
+	dirs := strings.Split(strings.TrimSuffix(dir, "/"), "/")
+	if len(dirs) < 2 || !isHex(name) {
+		return nil
+	}
+	for _, d := range dirs {
+		if len(d) != 5 || !isHex(d) {
+			return nil
+		}
+	}
+	// SHA-1, or SHA-256 and BLAKE3
+	if len(name) != 40 && len(name) != 64 {
+		return nil
+	}
+	if strings.Join(dirs, "/") != splitHash(name) {
+		return []Violation{{
+			Invariant: InvariantCASPaths,
+			Problem:   ProblemMisplaced,
+			Path:      fullPath,
+			Location:  fullPath,
+			Detail:    fmt.Sprintf("file belongs under %s", splitHash(name)),
+		}}
+	}
+	return nil
+}
+
+// splitHash splits a hex hash into the directories CASPathTransform names
+func splitHash(h string) string {
+	var dirs []string
+	for i := 0; i+5 <= len(h); i += 5 {
+		dirs = append(dirs, h[i:i+5])
+	}
+	return strings.Join(dirs, "/")
+}
+
+// isHex reports whether s is lowercase hex, of any length
+func isHex(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789abcdef") == ""
+}
+
+// checkObjectDir verifies the chunked object in the directory of that name
+// under the chunked layout, returning the number of chunks stored
+func (s *Store) checkObjectDir(name string) (int, []Violation, error) {
+	dir := filepath.Join(s.Root, chunkedDirName, name)
+	rel := path.Join(chunkedDirName, name)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, nil, err
+	}
+	stored := make(map[string]int64)
+	for _, e := range entries {
+		if e.Name() == manifestFileName {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return 0, nil, err
+		}
+		stored[e.Name()] = info.Size()
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, manifestFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		var violations []Violation
+		for _, chunk := range slices.Sorted(maps.Keys(stored)) {
+			violations = append(violations, Violation{
+				Invariant: InvariantRefcounts,
+				Problem:   ProblemOrphan,
+				Path:      rel,
+				Location:  rel,
+				Detail:    fmt.Sprintf("chunk %s is stored without a manifest", chunk),
+			})
+		}
+		return len(stored), violations, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return len(stored), []Violation{{
+			Invariant: InvariantManifestCoverage,
+			Problem:   ProblemCorrupt,
+			Path:      rel,
+			Location:  rel,
+			Detail:    fmt.Sprintf("corrupt manifest: %v", err),
+		}}, nil
+	}
+
+	path := m.Path
+	if path == "" {
+		path = rel
+	}
+	var violations []Violation
+	add := func(invariant, problem, format string, args ...any) {
+		violations = append(violations, Violation{
+			Invariant: invariant,
+			Problem:   problem,
+			Path:      path,
+			Location:  rel,
+			Detail:    fmt.Sprintf(format, args...),
+		})
+	}
+	if m.Path == "" {
+		add(InvariantCASPaths, ProblemCorrupt, "manifest names no path")
+	} else if s.objectDir(m.Path) != dir {
+		add(InvariantCASPaths, ProblemMisplaced, "object belongs in %s", filepath.Base(s.objectDir(m.Path)))
+	}
+
+	// Coverage
+	var covered int64
+	for i, c := range m.Chunks {
+		covered += c.Size
+		last := i == len(m.Chunks)-1
+		switch {
+		case c.Size <= 0:
+			add(InvariantManifestCoverage, ProblemCorrupt, "chunk %d is empty", i)
+		case c.Size > int64(m.ChunkSize):
+			add(InvariantManifestCoverage, ProblemCorrupt, "chunk %d is %d bytes, above the chunk size of %d", i, c.Size, m.ChunkSize)
+		case !last && c.Size != int64(m.ChunkSize):
+			add(InvariantManifestCoverage, ProblemCorrupt, "chunk %d is %d bytes, short of the chunk size of %d", i, c.Size, m.ChunkSize)
+		}
+	}
+	if covered != m.Size {
+		add(InvariantManifestCoverage, ProblemCorrupt, "chunks cover %d bytes of %d", covered, m.Size)
+	}
+
+	// Refcounts and chunk hashes
+	alg := m.hash()
+	refs := make(map[string]int)
+	hashes := make([][]byte, 0, len(m.Chunks))
+	for _, c := range m.Chunks {
+		refs[c.Hash]++
+		h, err := hex.DecodeString(c.Hash)
+		if err != nil {
+			add(InvariantCASPaths, ProblemCorrupt, "chunk name %q is not a hash", c.Hash)
+			continue
+		}
+		hashes = append(hashes, h)
+	}
+	for _, chunk := range slices.Sorted(maps.Keys(refs)) {
+		size, ok := stored[chunk]
+		if !ok {
+			add(InvariantRefcounts, ProblemDangling, "chunk %s is referenced %d times but not stored", chunk, refs[chunk])
+			continue
+		}
+		for _, c := range m.Chunks {
+			if c.Hash == chunk && c.Size != size {
+				problem := ProblemCorrupt
+				if size < c.Size {
+					problem = ProblemTruncated
+				}
+				add(InvariantManifestCoverage, problem, "chunk %s is %d bytes, the manifest says %d", chunk, size, c.Size)
+				break
+			}
+		}
+		content, err := os.ReadFile(filepath.Join(dir, chunk))
+		if err != nil {
+			return 0, nil, err
+		}
+		if got := hex.EncodeToString(alg.Sum(content)); got != chunk {
+			add(InvariantCASPaths, ProblemCorrupt, "chunk %s hashes to %s", chunk, got)
+		}
+	}
+	for _, chunk := range slices.Sorted(maps.Keys(stored)) {
+		if refs[chunk] == 0 {
+			add(InvariantRefcounts, ProblemOrphan, "chunk %s is stored with no references", chunk)
+		}
+	}
+	if len(hashes) == len(m.Chunks) {
+		if root := hex.EncodeToString(merkleRoot(alg, hashes)); root != m.MerkleRoot {
+			add(InvariantCASPaths, ProblemCorrupt, "merkle root is %s, the manifest says %s", root, m.MerkleRoot)
+		}
+	}
+	return len(stored), violations, nil
+}
+
+// verify reports the invariants broken around the objects an operation
+// touched to OnViolation
+func (s *Store) verify(op string, fullPaths ...string) {
+	if s.OnViolation == nil {
+		return
+	}
+	var violations []Violation
+	for _, fullPath := range fullPaths {
+		found, err := s.checkObject(fullPath)
+		if err != nil {
+			violations = append(violations, Violation{Invariant: "check", Path: fullPath, Location: fullPath, Detail: err.Error()})
+		}
+		violations = append(violations, found...)
+	}
+	if len(violations) > 0 {
+		s.OnViolation(op, violations)
+	}
+}
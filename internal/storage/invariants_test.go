@@ -0,0 +1,339 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStore_RandomOperationsKeepInvariants runs seeded random sequences of
+// writes, deletes, migrations, interrupted writes and collections against a
+// model of the store, checking the invariants after every operation
+func TestStore_RandomOperationsKeepInvariants(t *testing.T) {
+	for _, format := range []FormatVersion{FormatCAS, FormatChunked} {
+		for _, hash := range []HashAlgorithm{HashSHA1, HashSHA256, HashBLAKE3} {
+			t.Run(format.String()+"/"+string(hash), func(t *testing.T) {
+				for seed := uint64(1); seed <= 5; seed++ {
+					runRandomOperations(t, seed, format, hash)
+				}
+			})
+		}
+	}
+}
+
+func runRandomOperations(t *testing.T, seed uint64, format FormatVersion, hash HashAlgorithm) {
+	t.Helper()
+	rng := rand.New(rand.NewPCG(seed, uint64(format)))
+	s := NewStore(StoreOpts{
+		Root:      t.TempDir(),
+		ChunkSize: 8,
+		Hash:      hash,
+		OnViolation: func(op string, violations []Violation) {
+			t.Errorf("seed %d: %s broke invariants: %v", seed, op, violations)
+		},
+	})
+	if format == FormatChunked {
+		require.NoError(t, s.setFormat(FormatChunked))
+	}
+
+	model := make(map[string][]byte)
+	stale := 0
+	for step := 0; step < 100; step++ {
+		key := fmt.Sprintf("key-%d", rng.IntN(12))
+		switch op := rng.IntN(100); {
+		case op < 50:
+			data := randomContent(rng)
+			_, err := s.Write(key, bytes.NewReader(data))
+			if _, ok := model[key]; ok {
+				require.Error(t, err, "seed %d step %d: overwrote %s", seed, step, key)
+				continue
+			}
+			require.NoError(t, err, "seed %d step %d", seed, step)
+			model[key] = data
+		case op < 75:
+			require.NoError(t, s.Delete(key), "seed %d step %d", seed, step)
+			delete(model, key)
+		case op < 85:
+			report, err := s.GC()
+			require.NoError(t, err, "seed %d step %d", seed, step)
+			assert.Equal(t, stale, report.Staging, "seed %d step %d", seed, step)
+			assert.Zero(t, report.Objects+report.Chunks, "seed %d step %d: collected live data", seed, step)
+			stale = 0
+		case op < 95:
+			// A write interrupted by a crash leaves its staging directory
+			dir, err := s.stageDir()
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "chunk"), randomContent(rng), 0644))
+			s.staging.Delete(dir)
+			stale++
+		default:
+			if s.Format() == FormatChunked {
+				continue
+			}
+			m, err := NewMigrator(s, MigratorOpts{})
+			require.NoError(t, err)
+			require.NoError(t, m.Start())
+			require.NoError(t, m.Wait())
+			require.NoError(t, m.Finalize(), "seed %d step %d", seed, step)
+		}
+
+		if data, ok := model[key]; ok {
+			assert.Equal(t, data, readAll(t, s, key), "seed %d step %d", seed, step)
+		} else {
+			assert.False(t, s.Has(key), "seed %d step %d: %s survived its delete", seed, step, key)
+		}
+	}
+
+	report, err := s.Check()
+	require.NoError(t, err)
+	assert.Empty(t, report.Violations, "seed %d", seed)
+	assert.Equal(t, len(model), report.Objects, "seed %d", seed)
+	for key, data := range model {
+		assert.Equal(t, data, readAll(t, s, key), "seed %d", seed)
+	}
+}
+
+// randomContent returns up to five chunks of content, drawn from few
+// distinct chunks so objects repeat some
+func randomContent(rng *rand.Rand) []byte {
+	blocks := [][]byte{[]byte("aaaaaaaa"), []byte("bbbbbbbb"), []byte("01234567")}
+	data := []byte{}
+	for n := rng.IntN(5); n > 0; n-- {
+		data = append(data, blocks[rng.IntN(len(blocks))]...)
+	}
+	tail := make([]byte, rng.IntN(8))
+	for i := range tail {
+		tail[i] = byte(rng.IntN(256))
+	}
+	return append(data, tail...)
+}
+
+func invariants(violations []Violation) []string {
+	var names []string
+	for _, v := range violations {
+		names = append(names, v.Invariant)
+	}
+	return names
+}
+
+func problems(violations []Violation) []string {
+	var names []string
+	for _, v := range violations {
+		names = append(names, v.Problem)
+	}
+	return names
+}
+
+func editManifest(t *testing.T, dir string, edit func(*Manifest)) {
+	t.Helper()
+	path := filepath.Join(dir, manifestFileName)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var m Manifest
+	require.NoError(t, json.Unmarshal(data, &m))
+	edit(&m)
+	data, err = json.Marshal(m)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0644))
+}
+
+func TestCheck_DetectsBrokenInvariants(t *testing.T) {
+	chunk := func(content string) string { return hex.EncodeToString(HashSHA256.Sum([]byte(content))) }
+
+	for name, tc := range map[string]struct {
+		corrupt   func(t *testing.T, s *Store, dir string)
+		invariant string
+		problem   string
+	}{
+		"missing chunk": {func(t *testing.T, s *Store, dir string) {
+			require.NoError(t, os.Remove(filepath.Join(dir, chunk("bbbb"))))
+		}, InvariantRefcounts, ProblemDangling},
+		"unreferenced chunk": {func(t *testing.T, s *Store, dir string) {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, chunk("dddd")), []byte("dddd"), 0644))
+		}, InvariantRefcounts, ProblemOrphan},
+		"manifest removed": {func(t *testing.T, s *Store, dir string) {
+			require.NoError(t, os.Remove(filepath.Join(dir, manifestFileName)))
+		}, InvariantRefcounts, ProblemOrphan},
+		"chunk content changed": {func(t *testing.T, s *Store, dir string) {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, chunk("bbbb")), []byte("bbbx"), 0644))
+		}, InvariantCASPaths, ProblemCorrupt},
+		"object moved": {func(t *testing.T, s *Store, dir string) {
+			require.NoError(t, os.Rename(dir, s.objectDir("elsewhere")))
+		}, InvariantCASPaths, ProblemMisplaced},
+		"merkle root changed": {func(t *testing.T, s *Store, dir string) {
+			editManifest(t, dir, func(m *Manifest) { m.MerkleRoot = chunk("") })
+		}, InvariantCASPaths, ProblemCorrupt},
+		"truncated chunk": {func(t *testing.T, s *Store, dir string) {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, chunk("bbbb")), []byte("bb"), 0644))
+		}, InvariantManifestCoverage, ProblemTruncated},
+		"size past the chunks": {func(t *testing.T, s *Store, dir string) {
+			editManifest(t, dir, func(m *Manifest) { m.Size++ })
+		}, InvariantManifestCoverage, ProblemCorrupt},
+		"short chunk in the middle": {func(t *testing.T, s *Store, dir string) {
+			editManifest(t, dir, func(m *Manifest) {
+				m.Chunks[1].Size--
+				m.Size--
+			})
+		}, InvariantManifestCoverage, ProblemCorrupt},
+		"corrupt manifest": {func(t *testing.T, s *Store, dir string) {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, manifestFileName), []byte("{"), 0644))
+		}, InvariantManifestCoverage, ProblemCorrupt},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := NewStore(StoreOpts{Root: t.TempDir(), PathTransformFunc: CASPathTransformFunc, ChunkSize: 4})
+			require.NoError(t, s.setFormat(FormatChunked))
+			_, err := s.Write("object", bytes.NewReader([]byte("aaaabbbbaaaacc")))
+			require.NoError(t, err)
+			report, err := s.Check()
+			require.NoError(t, err)
+			require.Empty(t, report.Violations)
+			assert.Equal(t, 3, report.Chunks)
+
+			tc.corrupt(t, s, s.objectDir(CASPathTransformFunc("object").FullPath()))
+			report, err = s.Check()
+			require.NoError(t, err)
+			require.NotEmpty(t, report.Violations)
+			assert.Contains(t, invariants(report.Violations), tc.invariant)
+			assert.Contains(t, problems(report.Violations), tc.problem)
+			for _, v := range report.Violations {
+				assert.Regexp(t, `^\.v2/[0-9a-f]{64}$`, v.Location)
+			}
+		})
+	}
+}
+
+func TestCheck_MisplacedCASFile(t *testing.T) {
+	s := NewStore(StoreOpts{Root: t.TempDir(), PathTransformFunc: CASPathTransformFunc})
+	_, err := s.Write("object", bytes.NewReader([]byte("content")))
+	require.NoError(t, err)
+	report, err := s.Check()
+	require.NoError(t, err)
+	require.Empty(t, report.Violations)
+	assert.Equal(t, 1, report.Objects)
+
+	pathKey := CASPathTransformFunc("object")
+	moved := filepath.Join(s.Root, "00000", "00000", pathKey.Filename)
+	require.NoError(t, os.MkdirAll(filepath.Dir(moved), os.ModePerm))
+	require.NoError(t, os.Rename(s.legacyPath(pathKey.FullPath()), moved))
+	report, err = s.Check()
+	require.NoError(t, err)
+	require.Len(t, report.Violations, 1)
+	assert.Equal(t, InvariantCASPaths, report.Violations[0].Invariant)
+	assert.Equal(t, ProblemMisplaced, report.Violations[0].Problem)
+	assert.Equal(t, "00000/00000/"+pathKey.Filename, report.Violations[0].Path)
+	assert.Equal(t, report.Violations[0].Path, report.Violations[0].Location)
+}
+
+func TestStore_GC(t *testing.T) {
+	var reported []Violation
+	s := NewStore(StoreOpts{
+		Root:              t.TempDir(),
+		PathTransformFunc: CASPathTransformFunc,
+		ChunkSize:         4,
+		OnViolation:       func(op string, v []Violation) { reported = append(reported, v...) },
+	})
+	require.NoError(t, s.setFormat(FormatChunked))
+	_, err := s.Write("object", bytes.NewReader([]byte("aaaabbbbcc")))
+	require.NoError(t, err)
+	dir := s.objectDir(CASPathTransformFunc("object").FullPath())
+
+	inUse, err := s.stageDir()
+	require.NoError(t, err)
+	defer s.dropStage(inUse)
+	stale, err := s.stageDir()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(stale, "chunk"), []byte("12345"), 0644))
+	s.staging.Delete(stale)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "orphan"), []byte("123"), 0644))
+	require.NoError(t, os.MkdirAll(s.objectDir("abandoned"), os.ModePerm))
+
+	report, err := s.GC()
+	require.NoError(t, err)
+	assert.Equal(t, &GCReport{Staging: 1, Objects: 1, Chunks: 1, Bytes: 8}, report)
+	assert.DirExists(t, inUse)
+	assert.NoDirExists(t, stale)
+	assert.Empty(t, reported)
+	assert.Equal(t, []byte("aaaabbbbcc"), readAll(t, s, "object"))
+
+	// Collecting again finds nothing, and broken objects are reported
+	require.NoError(t, os.Remove(filepath.Join(dir, hex.EncodeToString(HashSHA256.Sum([]byte("cc"))))))
+	report, err = s.GC()
+	require.NoError(t, err)
+	assert.Equal(t, &GCReport{}, report)
+	assert.Equal(t, []string{InvariantRefcounts}, invariants(reported))
+}
+
+func TestStore_OnViolation(t *testing.T) {
+	reported := make(map[string][]Violation)
+	s := NewStore(StoreOpts{
+		Root:              t.TempDir(),
+		PathTransformFunc: CASPathTransformFunc,
+		ChunkSize:         4,
+		OnViolation:       func(op string, v []Violation) { reported[op] = append(reported[op], v...) },
+	})
+	require.NoError(t, s.setFormat(FormatChunked))
+	for _, key := range []string{"a", "b"} {
+		_, err := s.Write(key, bytes.NewReader([]byte("aaaabbbb")))
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Delete("a"))
+	assert.Empty(t, reported)
+
+	// A write checks the object it wrote only
+	require.NoError(t, os.WriteFile(filepath.Join(s.objectDir(CASPathTransformFunc("b").FullPath()), "orphan"), nil, 0644))
+	_, err := s.Write("c", bytes.NewReader([]byte("cccc")))
+	require.NoError(t, err)
+	assert.Empty(t, reported)
+
+	_, err = s.GC()
+	require.NoError(t, err)
+	assert.Empty(t, reported)
+	assert.Equal(t, []byte("aaaabbbb"), readAll(t, s, "b"))
+}
+
+func TestStore_Quarantine(t *testing.T) {
+	s := NewStore(StoreOpts{Root: t.TempDir(), PathTransformFunc: CASPathTransformFunc, ChunkSize: 4})
+	for _, key := range []string{"legacy", "other"} {
+		_, err := s.Write(key, bytes.NewReader([]byte("content")))
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.setFormat(FormatChunked))
+	_, err := s.Write("chunked", bytes.NewReader([]byte("aaaabbbb")))
+	require.NoError(t, err)
+
+	for _, key := range []string{"legacy", "chunked"} {
+		var moved []string
+		for _, location := range s.Locations(key) {
+			dst, err := s.Quarantine(location)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			require.NoError(t, err)
+			assert.Contains(t, dst, filepath.Join(s.Root, QuarantineDirName))
+			moved = append(moved, dst)
+		}
+		require.Len(t, moved, 1, key)
+		assert.False(t, s.Has(key))
+	}
+	assert.NoDirExists(t, filepath.Join(s.Root, CASPathTransformFunc("legacy").FirstPathName()))
+	assert.True(t, s.Has("other"))
+
+	report, err := s.Check()
+	require.NoError(t, err)
+	assert.Empty(t, report.Violations)
+	assert.Equal(t, 1, report.Objects)
+
+	for _, location := range []string{"", ".", "../x", "/etc", "FORMAT", ".keys/current", ".v2", ".v2/.tmp-1", ".v2/a/b", QuarantineDirName} {
+		_, err := s.Quarantine(location)
+		assert.Error(t, err, location)
+	}
+}
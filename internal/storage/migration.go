@@ -0,0 +1,704 @@
+package storage
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/upgrade"
+)
+
+var (
+	ErrMigrationRunning   = errors.New("storage: migration is running")
+	ErrMigrationFinalized = errors.New("storage: migration already finalized")
+	ErrMigrationNotDone   = errors.New("storage: migration has not completed")
+)
+
+const migrationStateDir = ".migration"
+
+const (
+	// MigrationTokenEnv is the environment variable holding the bearer
+	// token of the migration controls served by MigrationHandler
+	MigrationTokenEnv = "PEERVAULT_STORAGE_ADMIN_TOKEN"
+	// DefaultMigrationHost is the host migration controls listen on when
+	// their address names none, so they stay off the network by default
+	DefaultMigrationHost = "127.0.0.1"
+)
+
+// ErrNoMigrationToken is returned when migration controls are served
+// without a token
+var ErrNoMigrationToken = errors.New("storage: migration controls require a token")
+
+// MigrationStatus is the lifecycle state of a format migration
+type MigrationStatus string
+
+const (
+	MigrationIdle       MigrationStatus = "idle"
+	MigrationRunning    MigrationStatus = "running"
+	MigrationPaused     MigrationStatus = "paused"
+	MigrationCompleted  MigrationStatus = "completed"
+	MigrationFinalized  MigrationStatus = "finalized"
+	MigrationRolledBack MigrationStatus = "rolled_back"
+)
+
+// ObjectStatus is the migration state of a single object
+type ObjectStatus string
+
+const (
+	ObjectPending  ObjectStatus = "pending"
+	ObjectMigrated ObjectStatus = "migrated"
+	ObjectFailed   ObjectStatus = "failed"
+)
+
+// ObjectMigration tracks one object through the migration
+type ObjectMigration struct {
+	Status     ObjectStatus `json:"status"`
+	Size       int64        `json:"size,omitempty"`
+	Chunks     int          `json:"chunks,omitempty"`
+	MerkleRoot string       `json:"merkle_root,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+}
+
+// MigrationState is the persisted state of a migration, reloaded on restart
+// so an interrupted migration resumes where it stopped
+type MigrationState struct {
+	From        FormatVersion               `json:"from"`
+	To          FormatVersion               `json:"to"`
+	Status      MigrationStatus             `json:"status"`
+	StartedAt   time.Time                   `json:"started_at,omitempty"`
+	UpdatedAt   time.Time                   `json:"updated_at,omitempty"`
+	CompletedAt time.Time                   `json:"completed_at,omitempty"`
+	Objects     map[string]*ObjectMigration `json:"objects"`
+}
+
+// MigrationProgress is a point-in-time summary of a migration
+type MigrationProgress struct {
+	From        string          `json:"from"`
+	To          string          `json:"to"`
+	Status      MigrationStatus `json:"status"`
+	Total       int             `json:"total"`
+	Migrated    int             `json:"migrated"`
+	Failed      int             `json:"failed"`
+	Pending     int             `json:"pending"`
+	StartedAt   time.Time       `json:"started_at,omitempty"`
+	UpdatedAt   time.Time       `json:"updated_at,omitempty"`
+	CompletedAt time.Time       `json:"completed_at,omitempty"`
+}
+
+// MigratorOpts configures a Migrator
+type MigratorOpts struct {
+	// Throttle is slept between objects to bound the I/O taken from live traffic
+	Throttle time.Duration
+	// CheckpointEvery persists the state after this many objects (default 100)
+	CheckpointEvery int
+	// RetryInterval is how long to wait before revisiting objects that were
+	// being written when first seen (default 100ms)
+	RetryInterval time.Duration
+}
+
+// Migrator moves a store from the CAS layout to the chunked layout in the
+// background. Both layouts are readable throughout; CAS files remain the
+// source of truth until Finalize, so Rollback only has to drop the chunked
+// copies.
+type Migrator struct {
+	store *Store
+	opts  MigratorOpts
+
+	mu      sync.Mutex
+	state   *MigrationState
+	paused  bool
+	resume  chan struct{}
+	cancel  context.CancelFunc
+	done    chan struct{}
+	lastErr error
+}
+
+// NewMigrator creates a migrator for the store, loading any persisted state
+func NewMigrator(store *Store, opts MigratorOpts) (*Migrator, error) {
+	if opts.CheckpointEvery <= 0 {
+		opts.CheckpointEvery = 100
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = 100 * time.Millisecond
+	}
+
+	m := &Migrator{store: store, opts: opts, resume: make(chan struct{})}
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	if state.Status == MigrationRunning {
+		// The process stopped mid-run; Start picks it up again
+		state.Status = MigrationPaused
+	}
+	if store.Format() == FormatChunked {
+		state.Status = MigrationFinalized
+	}
+	m.state = state
+	return m, nil
+}
+
+func (m *Migrator) statePath() string {
+	return filepath.Join(m.store.Root, migrationStateDir, "state.json")
+}
+
+func (m *Migrator) loadState() (*MigrationState, error) {
+	data, err := os.ReadFile(m.statePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return &MigrationState{
+			From:    FormatCAS,
+			To:      FormatChunked,
+			Status:  MigrationIdle,
+			Objects: make(map[string]*ObjectMigration),
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state MigrationState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("corrupt migration state: %w", err)
+	}
+	if state.Objects == nil {
+		state.Objects = make(map[string]*ObjectMigration)
+	}
+	return &state, nil
+}
+
+// saveLocked persists the state; callers hold m.mu
+func (m *Migrator) saveLocked() error {
+	if err := os.MkdirAll(filepath.Dir(m.statePath()), os.ModePerm); err != nil {
+		return err
+	}
+	m.state.UpdatedAt = time.Now()
+	data, err := json.Marshal(m.state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.statePath(), data)
+}
+
+// Start begins (or resumes after a restart) the background migration
+func (m *Migrator) Start() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch m.state.Status {
+	case MigrationRunning:
+		return ErrMigrationRunning
+	case MigrationFinalized:
+		return ErrMigrationFinalized
+	case MigrationIdle, MigrationRolledBack:
+		m.state.StartedAt = time.Now()
+		m.state.CompletedAt = time.Time{}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	m.paused = false
+	m.lastErr = nil
+	m.state.Status = MigrationRunning
+	if err := m.saveLocked(); err != nil {
+		cancel()
+		return err
+	}
+
+	go m.run(ctx, m.done)
+	return nil
+}
+
+// Pause suspends the migration after the object currently being copied
+func (m *Migrator) Pause() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state.Status != MigrationRunning {
+		return fmt.Errorf("storage: cannot pause migration in state %s", m.state.Status)
+	}
+	m.paused = true
+	m.state.Status = MigrationPaused
+	return m.saveLocked()
+}
+
+// Resume continues a paused migration, restarting the worker if the pause
+// was inherited from a previous process
+func (m *Migrator) Resume() error {
+	m.mu.Lock()
+	if m.state.Status != MigrationPaused {
+		status := m.state.Status
+		m.mu.Unlock()
+		return fmt.Errorf("storage: cannot resume migration in state %s", status)
+	}
+	if m.done == nil {
+		m.mu.Unlock()
+		return m.Start()
+	}
+	defer m.mu.Unlock()
+	m.paused = false
+	m.state.Status = MigrationRunning
+	close(m.resume)
+	m.resume = make(chan struct{})
+	return m.saveLocked()
+}
+
+// Wait blocks until the background worker exits and returns its error
+func (m *Migrator) Wait() error {
+	m.mu.Lock()
+	done := m.done
+	m.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastErr
+}
+
+// Stop halts the worker, leaving the migration paused so it can be resumed
+func (m *Migrator) Stop() {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Progress returns a summary of the migration
+func (m *Migrator) Progress() MigrationProgress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := MigrationProgress{
+		From:        m.state.From.String(),
+		To:          m.state.To.String(),
+		Status:      m.state.Status,
+		Total:       len(m.state.Objects),
+		StartedAt:   m.state.StartedAt,
+		UpdatedAt:   m.state.UpdatedAt,
+		CompletedAt: m.state.CompletedAt,
+	}
+	for _, obj := range m.state.Objects {
+		switch obj.Status {
+		case ObjectMigrated:
+			p.Migrated++
+		case ObjectFailed:
+			p.Failed++
+		default:
+			p.Pending++
+		}
+	}
+	return p
+}
+
+// Object returns the migration state of a single object by its full path
+func (m *Migrator) Object(fullPath string) (ObjectMigration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, ok := m.state.Objects[fullPath]
+	if !ok {
+		return ObjectMigration{}, false
+	}
+	return *obj, true
+}
+
+func (m *Migrator) run(ctx context.Context, done chan struct{}) {
+	err := m.migrateAll(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer close(done)
+	m.cancel, m.done = nil, nil
+	m.lastErr = err
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		if m.state.Status == MigrationRunning {
+			m.state.Status = MigrationPaused
+		}
+		m.lastErr = nil
+	case err != nil:
+		slog.Error("storage migration stopped", "error", err)
+		m.state.Status = MigrationPaused
+	default:
+		m.state.Status = MigrationCompleted
+		m.state.CompletedAt = time.Now()
+		slog.Info("storage migration completed", "objects", len(m.state.Objects))
+	}
+	if err := m.saveLocked(); err != nil {
+		slog.Error("failed to save migration state", "error", err)
+	}
+}
+
+// migrateAll makes passes over the legacy objects until every object that is
+// not being written has been copied
+func (m *Migrator) migrateAll(ctx context.Context) error {
+	for {
+		pending, err := m.discover()
+		if err != nil {
+			return err
+		}
+
+		skipped := 0
+		for i, fullPath := range pending {
+			if err := m.waitIfPaused(ctx); err != nil {
+				return err
+			}
+			if m.store.writing(fullPath) {
+				skipped++
+				continue
+			}
+
+			m.migrateOne(fullPath)
+
+			if (i+1)%m.opts.CheckpointEvery == 0 {
+				m.mu.Lock()
+				err := m.saveLocked()
+				m.mu.Unlock()
+				if err != nil {
+					return err
+				}
+			}
+			if m.opts.Throttle > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(m.opts.Throttle):
+				}
+			}
+		}
+
+		if skipped == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.opts.RetryInterval):
+		}
+	}
+}
+
+func (m *Migrator) waitIfPaused(ctx context.Context) error {
+	for {
+		m.mu.Lock()
+		paused, resume := m.paused, m.resume
+		m.mu.Unlock()
+		if !paused {
+			return ctx.Err()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-resume:
+		}
+	}
+}
+
+// discover registers legacy objects in the state and returns the ones that
+// still need copying, in a stable order
+func (m *Migrator) discover() ([]string, error) {
+	paths, err := m.store.legacyObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var pending []string
+	for _, fullPath := range paths {
+		obj, ok := m.state.Objects[fullPath]
+		if !ok {
+			obj = &ObjectMigration{Status: ObjectPending, UpdatedAt: time.Now()}
+			m.state.Objects[fullPath] = obj
+		}
+		if obj.Status == ObjectMigrated && !m.store.hasChunked(fullPath) {
+			// Deleted and written again since it was copied
+			obj.Status = ObjectPending
+		}
+		if obj.Status != ObjectMigrated {
+			pending = append(pending, fullPath)
+		}
+	}
+	return pending, nil
+}
+
+func (m *Migrator) migrateOne(fullPath string) {
+	manifest, err := m.store.migrateObject(fullPath)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj := m.state.Objects[fullPath]
+	obj.UpdatedAt = time.Now()
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// Deleted while migrating
+		delete(m.state.Objects, fullPath)
+	case err != nil:
+		obj.Status = ObjectFailed
+		obj.Error = err.Error()
+		slog.Warn("failed to migrate object", "path", fullPath, "error", err)
+	default:
+		obj.Status = ObjectMigrated
+		obj.Error = ""
+		obj.Size = manifest.Size
+		obj.Chunks = len(manifest.Chunks)
+		obj.MerkleRoot = manifest.MerkleRoot
+	}
+}
+
+// Rollback discards all chunked copies and returns the store to the state it
+// was in before the migration started. It is not possible after Finalize.
+func (m *Migrator) Rollback() error {
+	m.Stop()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state.Status == MigrationFinalized {
+		return ErrMigrationFinalized
+	}
+
+	m.store.mu.Lock()
+	err := os.RemoveAll(filepath.Join(m.store.Root, chunkedDirName))
+	m.store.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	m.state.Objects = make(map[string]*ObjectMigration)
+	m.state.Status = MigrationRolledBack
+	m.state.CompletedAt = time.Time{}
+	return m.saveLocked()
+}
+
+// Finalize switches the store to the chunked layout and removes the CAS
+// files. New writes go to the chunked layout first, objects written since the
+// migration completed are copied, and only then are CAS files deleted.
+func (m *Migrator) Finalize() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch m.state.Status {
+	case MigrationFinalized:
+		return ErrMigrationFinalized
+	case MigrationCompleted:
+	default:
+		return ErrMigrationNotDone
+	}
+	for path, obj := range m.state.Objects {
+		if obj.Status == ObjectFailed {
+			return fmt.Errorf("storage: cannot finalize, %s failed to migrate: %s", path, obj.Error)
+		}
+	}
+
+	if err := m.store.setFormat(FormatChunked); err != nil {
+		return err
+	}
+
+	for {
+		paths, err := m.store.legacyObjects()
+		if err != nil {
+			return err
+		}
+		busy := false
+		for _, fullPath := range paths {
+			if m.store.writing(fullPath) {
+				busy = true
+				continue
+			}
+			if _, err := m.store.migrateObject(fullPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("storage: finalize %s: %w", fullPath, err)
+			}
+			if err := m.store.dropLegacy(fullPath); err != nil {
+				return err
+			}
+		}
+		if !busy {
+			break
+		}
+		time.Sleep(m.opts.RetryInterval)
+	}
+
+	m.state.Status = MigrationFinalized
+	return m.saveLocked()
+}
+
+// legacyObjects lists CAS files relative to the root, skipping the chunked
+// layout and bookkeeping files
+func (s *Store) legacyObjects() ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(s.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(s.Root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel == chunkedDirName || rel == migrationStateDir || rel == KeysDirName || rel == QuarantineDirName || rel == upgrade.BackupDirName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch rel {
+		case formatFileName, formatFileName + ".tmp", hashFileName, hashFileName + ".tmp", IdentityFileName, upgrade.VersionFileName, upgrade.VersionFileName + ".tmp":
+			return nil
+		}
+		paths = append(paths, rel)
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, err
+}
+
+// migrateObject copies a CAS file into the chunked layout. The copy is built
+// in a staging directory and moved into place under the store lock, after
+// checking the CAS file was not deleted in the meantime.
+func (s *Store) migrateObject(fullPath string) (*Manifest, error) {
+	if m, err := s.readManifest(fullPath); err == nil {
+		return m, nil
+	}
+
+	f, err := os.Open(s.legacyPath(fullPath))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stage, err := s.stageDir()
+	if err != nil {
+		return nil, err
+	}
+	defer s.dropStage(stage)
+
+	w := newChunkWriter(stage, s.ChunkSize, s.Hash())
+	if _, err := io.Copy(w, f); err != nil {
+		return nil, err
+	}
+	manifest, err := w.finish(fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := os.Stat(s.legacyPath(fullPath)); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(stage, s.objectDir(fullPath)); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// dropLegacy removes a CAS file once its chunked copy exists, pruning any
+// directories left empty
+func (s *Store) dropLegacy(fullPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.hasChunked(fullPath) {
+		return nil
+	}
+	if err := os.Remove(s.legacyPath(fullPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	s.pruneDirs(fullPath)
+	return nil
+}
+
+// MigrationHandler exposes migration controls to requests bearing token.
+// Rollback and finalize delete one of the two copies of the store, so
+// without a token every request is refused.
+//
+//	GET  /storage/migration
+//	POST /storage/migration/{start,pause,resume,rollback,finalize}
+//	GET  /storage/hash
+func MigrationHandler(m *Migrator, token string) http.Handler {
+	mux := http.NewServeMux()
+	writeProgress := func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(m.Progress()); err != nil {
+			slog.Error("failed to encode migration progress", "error", err)
+		}
+	}
+
+	mux.HandleFunc("GET /storage/migration", func(w http.ResponseWriter, r *http.Request) {
+		writeProgress(w)
+	})
+
+	actions := map[string]func() error{
+		"start":    m.Start,
+		"pause":    m.Pause,
+		"resume":   m.Resume,
+		"rollback": m.Rollback,
+		"finalize": m.Finalize,
+	}
+	mux.HandleFunc("POST /storage/migration/{action}", func(w http.ResponseWriter, r *http.Request) {
+		action, ok := actions[r.PathValue("action")]
+		if !ok {
+			http.Error(w, "unknown migration action", http.StatusNotFound)
+			return
+		}
+		if err := action(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeProgress(w)
+	})
+
+	mux.HandleFunc("GET /storage/hash", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(m.store.HashStatus()); err != nil {
+			slog.Error("failed to encode hash status", "error", err)
+		}
+	})
+	return requireToken(token, mux)
+}
+
+// requireToken rejects requests without the bearer token
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="storage"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MigrationAddr returns addr with DefaultMigrationHost as its host when it
+// names none, as in ":7070"
+func MigrationAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("storage: invalid migration address %q: %w", addr, err)
+	}
+	if host == "" {
+		host = DefaultMigrationHost
+	}
+	return net.JoinHostPort(host, port), nil
+}
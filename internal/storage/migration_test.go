@@ -0,0 +1,298 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMigrationStore(t *testing.T) *Store {
+	t.Helper()
+	return NewStore(StoreOpts{
+		Root:              filepath.Join(t.TempDir(), "root"),
+		PathTransformFunc: CASPathTransformFunc,
+		ChunkSize:         16,
+	})
+}
+
+func readAll(t *testing.T, s *Store, key string) []byte {
+	t.Helper()
+	_, r, err := s.Read(key)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return data
+}
+
+func writeObjects(t *testing.T, s *Store, n int) map[string][]byte {
+	t.Helper()
+	objects := make(map[string][]byte)
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("object-%d", i)
+		data := bytes.Repeat([]byte{byte('a' + i)}, 10+i*7)
+		_, err := s.Write(key, bytes.NewReader(data))
+		require.NoError(t, err)
+		objects[key] = data
+	}
+	return objects
+}
+
+func TestMerkleRoot(t *testing.T) {
+	a, b, c := []byte{1}, []byte{2}, []byte{3}
+	assert.Equal(t, a, merkleRoot(HashSHA256, [][]byte{a}))
+	assert.NotEqual(t, merkleRoot(HashSHA256, [][]byte{a, b}), merkleRoot(HashSHA256, [][]byte{b, a}))
+	assert.Len(t, merkleRoot(HashSHA256, [][]byte{a, b, c}), 32)
+	assert.Len(t, merkleRoot(HashSHA256, nil), 32)
+}
+
+func TestMigrator_MigrateAndFinalize(t *testing.T) {
+	s := newMigrationStore(t)
+	objects := writeObjects(t, s, 5)
+	assert.Equal(t, FormatCAS, s.Format())
+
+	m, err := NewMigrator(s, MigratorOpts{})
+	require.NoError(t, err)
+	require.NoError(t, m.Start())
+	require.NoError(t, m.Wait())
+
+	p := m.Progress()
+	assert.Equal(t, MigrationCompleted, p.Status)
+	assert.Equal(t, 5, p.Total)
+	assert.Equal(t, 5, p.Migrated)
+
+	obj, ok := m.Object(CASPathTransformFunc("object-4").FullPath())
+	require.True(t, ok)
+	assert.Equal(t, ObjectMigrated, obj.Status)
+	assert.Equal(t, int64(38), obj.Size)
+	assert.Equal(t, 3, obj.Chunks)
+	assert.NotEmpty(t, obj.MerkleRoot)
+
+	// Written after the background pass; picked up by Finalize
+	_, err = s.Write("late", bytes.NewReader([]byte("late object")))
+	require.NoError(t, err)
+	objects["late"] = []byte("late object")
+
+	require.NoError(t, m.Finalize())
+	assert.Equal(t, FormatChunked, s.Format())
+
+	legacy, err := s.legacyObjects()
+	require.NoError(t, err)
+	assert.Empty(t, legacy)
+
+	for key, data := range objects {
+		assert.True(t, s.Has(key))
+		assert.Equal(t, data, readAll(t, s, key), key)
+	}
+
+	// New writes use the chunked layout and keep create-once semantics
+	_, err = s.Write("fresh", bytes.NewReader([]byte("fresh data")))
+	require.NoError(t, err)
+	assert.True(t, s.hasChunked(CASPathTransformFunc("fresh").FullPath()))
+	_, err = s.Write("fresh", bytes.NewReader([]byte("again")))
+	assert.ErrorContains(t, err, "already exists")
+
+	require.NoError(t, s.Delete("fresh"))
+	assert.False(t, s.Has("fresh"))
+
+	// The format survives a restart
+	reopened := NewStore(s.StoreOpts)
+	assert.Equal(t, FormatChunked, reopened.Format())
+	m2, err := NewMigrator(reopened, MigratorOpts{})
+	require.NoError(t, err)
+	assert.ErrorIs(t, m2.Start(), ErrMigrationFinalized)
+}
+
+func TestMigrator_Rollback(t *testing.T) {
+	s := newMigrationStore(t)
+	objects := writeObjects(t, s, 3)
+
+	m, err := NewMigrator(s, MigratorOpts{})
+	require.NoError(t, err)
+	require.NoError(t, m.Start())
+	require.NoError(t, m.Wait())
+
+	require.NoError(t, m.Rollback())
+	assert.Equal(t, MigrationRolledBack, m.Progress().Status)
+	assert.Equal(t, FormatCAS, s.Format())
+	_, err = os.Stat(filepath.Join(s.Root, chunkedDirName))
+	assert.True(t, os.IsNotExist(err))
+
+	for key, data := range objects {
+		assert.Equal(t, data, readAll(t, s, key))
+	}
+
+	// A rolled back migration can be started again
+	require.NoError(t, m.Start())
+	require.NoError(t, m.Wait())
+	assert.Equal(t, 3, m.Progress().Migrated)
+}
+
+func TestMigrator_PauseResumeAcrossRestart(t *testing.T) {
+	s := newMigrationStore(t)
+	writeObjects(t, s, 4)
+
+	m, err := NewMigrator(s, MigratorOpts{Throttle: 50 * time.Millisecond})
+	require.NoError(t, err)
+	assert.Error(t, m.Pause())
+
+	// Simulate a process that stopped part way through
+	require.NoError(t, m.Start())
+	require.NoError(t, m.Pause())
+	m.Stop()
+	assert.Equal(t, MigrationPaused, m.Progress().Status)
+
+	m2, err := NewMigrator(s, MigratorOpts{})
+	require.NoError(t, err)
+	assert.Equal(t, MigrationPaused, m2.Progress().Status)
+	assert.ErrorIs(t, m2.Finalize(), ErrMigrationNotDone)
+
+	require.NoError(t, m2.Resume())
+	require.NoError(t, m2.Wait())
+	assert.Equal(t, MigrationCompleted, m2.Progress().Status)
+	assert.Equal(t, 4, m2.Progress().Migrated)
+}
+
+func TestMigrator_SkipsInflightWrites(t *testing.T) {
+	s := newMigrationStore(t)
+	writeObjects(t, s, 1)
+	busy := CASPathTransformFunc("object-0").FullPath()
+
+	done := s.beginLegacyWrite("object-0")
+	m, err := NewMigrator(s, MigratorOpts{})
+	require.NoError(t, err)
+	require.NoError(t, m.Start())
+
+	pending, err := m.discover()
+	require.NoError(t, err)
+	assert.Equal(t, []string{busy}, pending)
+	assert.False(t, s.hasChunked(busy))
+
+	done()
+	require.NoError(t, m.Wait())
+	assert.True(t, s.hasChunked(busy))
+}
+
+func TestMigrator_DeleteDuringMigration(t *testing.T) {
+	s := newMigrationStore(t)
+	writeObjects(t, s, 2)
+
+	m, err := NewMigrator(s, MigratorOpts{})
+	require.NoError(t, err)
+	require.NoError(t, m.Start())
+	require.NoError(t, m.Wait())
+
+	require.NoError(t, s.Delete("object-1"))
+	assert.False(t, s.Has("object-1"))
+	assert.False(t, s.hasChunked(CASPathTransformFunc("object-1").FullPath()))
+
+	require.NoError(t, m.Finalize())
+	assert.True(t, s.Has("object-0"))
+	assert.False(t, s.Has("object-1"))
+}
+
+func TestChunkReader_DetectsCorruption(t *testing.T) {
+	s := newMigrationStore(t)
+	require.NoError(t, s.setFormat(FormatChunked))
+	_, err := s.Write("key", bytes.NewReader([]byte("some chunked bytes")))
+	require.NoError(t, err)
+
+	fullPath := CASPathTransformFunc("key").FullPath()
+	manifest, err := s.readManifest(fullPath)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(s.objectDir(fullPath), manifest.Chunks[0].Hash), []byte("tampered"), 0644))
+
+	_, r, err := s.Read("key")
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorContains(t, err, "failed verification")
+}
+
+func TestStore_RecordFormat(t *testing.T) {
+	s := newMigrationStore(t)
+	writeObjects(t, s, 2)
+	formatFile := filepath.Join(s.Root, formatFileName)
+	assert.NoFileExists(t, formatFile)
+
+	changes, err := s.RecordFormat(true)
+	require.NoError(t, err)
+	assert.Len(t, changes, 1)
+	assert.NoFileExists(t, formatFile, "a dry run changes nothing")
+
+	changes, err = s.RecordFormat(false)
+	require.NoError(t, err)
+	assert.Len(t, changes, 1)
+	assert.FileExists(t, formatFile)
+	assert.Equal(t, FormatCAS, NewStore(s.StoreOpts).Format())
+	legacy, err := s.legacyObjects()
+	require.NoError(t, err)
+	assert.Len(t, legacy, 2)
+
+	// A recorded format is left as it is
+	require.NoError(t, s.setFormat(FormatChunked))
+	changes, err = s.RecordFormat(false)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+	assert.Equal(t, FormatChunked, NewStore(s.StoreOpts).Format())
+}
+
+func TestMigrationHandler_RequiresToken(t *testing.T) {
+	s := newMigrationStore(t)
+	objects := writeObjects(t, s, 3)
+	m, err := NewMigrator(s, MigratorOpts{})
+	require.NoError(t, err)
+	require.NoError(t, m.Start())
+	require.NoError(t, m.Wait())
+
+	post := func(h http.Handler, action, auth string) int {
+		req := httptest.NewRequest(http.MethodPost, "/storage/migration/"+action, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	h := MigrationHandler(m, "admin-token")
+	for _, auth := range []string{"", "Bearer wrong-token", "admin-token"} {
+		for _, action := range []string{"rollback", "finalize"} {
+			assert.Equal(t, http.StatusUnauthorized, post(h, action, auth), "%s with %q", action, auth)
+		}
+	}
+	// Without a token no request is let through
+	assert.Equal(t, http.StatusUnauthorized, post(MigrationHandler(m, ""), "rollback", "Bearer "))
+
+	// Neither copy of the store was touched
+	assert.Equal(t, MigrationCompleted, m.Progress().Status)
+	_, err = os.Stat(filepath.Join(s.Root, chunkedDirName))
+	assert.NoError(t, err, "rollback did not remove the chunked copy")
+	for key, data := range objects {
+		_, err := os.Stat(s.legacyPath(CASPathTransformFunc(key).FullPath()))
+		assert.NoError(t, err, "finalize did not remove %s", key)
+		assert.Equal(t, data, readAll(t, s, key))
+	}
+
+	assert.Equal(t, http.StatusOK, post(h, "rollback", "Bearer admin-token"))
+	assert.Equal(t, MigrationRolledBack, m.Progress().Status)
+}
+
+func TestMigrationAddr(t *testing.T) {
+	addr, err := MigrationAddr(":7000")
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:7000", addr)
+	addr, err = MigrationAddr("0.0.0.0:7000")
+	require.NoError(t, err)
+	assert.Equal(t, "0.0.0.0:7000", addr)
+	_, err = MigrationAddr("7000")
+	assert.Error(t, err)
+}
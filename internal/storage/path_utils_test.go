@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -157,3 +158,13 @@ func TestPathSanitizationEdgeCases(t *testing.T) {
 		t.Errorf("SanitizePath(\"valid:invalid:valid\") = %q, want %q", result, expected)
 	}
 }
+
+func TestNewStoreKeepsAbsoluteRoot(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "store")
+	if s := NewStore(StoreOpts{Root: root}); s.Root != root {
+		t.Errorf("Root = %q, want %q", s.Root, root)
+	}
+	if s := NewStore(StoreOpts{Root: ":3000_network"}); s.Root != "_3000_network" {
+		t.Errorf("Root = %q, want %q", s.Root, "_3000_network")
+	}
+}
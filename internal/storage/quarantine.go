@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/upgrade"
+)
+
+// QuarantineDirName is the directory at the root of the store holding the
+// objects set aside by Quarantine, one directory per quarantine
+const QuarantineDirName = ".quarantine"
+
+// Locations returns the locations key may be stored at, relative to the
+// root as Violation.Location names them: its CAS file and its chunked
+// object directory under the current hash, then under the previous ones
+func (s *Store) Locations(key string) []string {
+	transforms := []PathTransformFunc{s.PathTransformFunc}
+	for _, p := range s.previous {
+		transforms = append(transforms, p.transform)
+	}
+	var locations []string
+	for _, transform := range transforms {
+		fullPath := transform(key).FullPath()
+		locations = append(locations, fullPath, path.Join(chunkedDirName, filepath.Base(s.objectDir(fullPath))))
+	}
+	return locations
+}
+
+// Quarantine moves the file or chunked object directory at location out of
+// the store, into a directory under QuarantineDirName named by the time,
+// and returns where it went. What is quarantined is kept for inspection;
+// the store no longer sees it.
+func (s *Store) Quarantine(location string) (string, error) {
+	if path.Clean(location) != location || location == "." || location == ".." || path.IsAbs(location) || strings.HasPrefix(location, "../") {
+		return "", fmt.Errorf("invalid location %q", location)
+	}
+	top := strings.SplitN(location, "/", 2)[0]
+	switch {
+	case top == chunkedDirName:
+		name, ok := strings.CutPrefix(location, chunkedDirName+"/")
+		if !ok || strings.Contains(name, "/") || strings.HasPrefix(name, tmpPrefix) {
+			return "", fmt.Errorf("invalid location %q", location)
+		}
+	case top == QuarantineDirName || top == migrationStateDir || top == KeysDirName || top == upgrade.BackupDirName,
+		location == formatFileName || location == hashFileName || location == IdentityFileName || location == upgrade.VersionFileName:
+		return "", fmt.Errorf("location %q is not an object", location)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src := filepath.Join(s.Root, filepath.FromSlash(location))
+	if _, err := os.Lstat(src); err != nil {
+		return "", err
+	}
+	dst := filepath.Join(s.Root, QuarantineDirName, time.Now().UTC().Format("20060102T150405.000000000Z"), filepath.FromSlash(location))
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return "", err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return "", err
+	}
+	if top != chunkedDirName {
+		s.pruneDirs(location)
+	}
+	return dst, nil
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// KeysDirName is the directory at the root of the store holding the state
+// of encryption key rotation, next to the objects
+const KeysDirName = ".keys"
+
+// ErrObjectChanged is returned by RewriteObject when the object is written
+// while it is rewritten; the rewrite is dropped and can be retried
+var ErrObjectChanged = errors.New("storage: object changed while being rewritten")
+
+// Objects lists the objects of the store by full path, in a stable order.
+// Objects present in both layouts during a migration are listed once.
+func (s *Store) Objects() ([]string, error) {
+	paths, err := s.legacyObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.Root, chunkedDirName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), tmpPrefix) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.Root, chunkedDirName, e.Name(), manifestFileName))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var m Manifest
+		if err := json.Unmarshal(data, &m); err != nil || m.Path == "" {
+			continue
+		}
+		paths = append(paths, m.Path)
+	}
+	slices.Sort(paths)
+	return slices.Compact(paths), nil
+}
+
+// ReadObject opens an object by its full path, as listed by Objects
+func (s *Store) ReadObject(fullPath string) (int64, io.ReadCloser, error) {
+	f, err := os.Open(s.legacyPath(fullPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return s.readChunked(fullPath)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return 0, nil, err
+	}
+	return fi.Size(), f, nil
+}
+
+// RewriteObject replaces the content of an object, in whichever layout it
+// is read from, with what rewrite writes given the current content. The
+// new content is moved into place under the store lock, and only if the
+// object was neither written nor deleted in the meantime; otherwise the
+// rewrite is dropped with ErrObjectChanged or os.ErrNotExist.
+func (s *Store) RewriteObject(fullPath string, rewrite func(r io.Reader, w io.Writer) error) error {
+	if s.writing(fullPath) {
+		return ErrObjectChanged
+	}
+	f, err := os.Open(s.legacyPath(fullPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return s.rewriteChunked(fullPath, rewrite)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.rewriteLegacy(fullPath, f, rewrite)
+}
+
+func (s *Store) rewriteLegacy(fullPath string, f *os.File, rewrite func(io.Reader, io.Writer) error) error {
+	before, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	stage, err := s.stageDir()
+	if err != nil {
+		return err
+	}
+	defer s.dropStage(stage)
+
+	tmp := filepath.Join(stage, "object")
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	err = rewrite(f, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now, err := os.Stat(s.legacyPath(fullPath))
+	if err != nil {
+		return err
+	}
+	if s.writing(fullPath) || !os.SameFile(before, now) || !now.ModTime().Equal(before.ModTime()) || now.Size() != before.Size() {
+		return ErrObjectChanged
+	}
+	return os.Rename(tmp, s.legacyPath(fullPath))
+}
+
+func (s *Store) rewriteChunked(fullPath string, rewrite func(io.Reader, io.Writer) error) error {
+	before, err := s.readManifest(fullPath)
+	if err != nil {
+		return err
+	}
+	stage, err := s.stageDir()
+	if err != nil {
+		return err
+	}
+	defer s.dropStage(stage)
+
+	w := newChunkWriter(stage, s.ChunkSize, s.Hash())
+	r := &chunkReader{dir: s.objectDir(fullPath), hash: before.hash(), chunks: before.Chunks}
+	if err := rewrite(r, w); err != nil {
+		return err
+	}
+	if _, err := w.finish(fullPath); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now, err := s.readManifest(fullPath)
+	if err != nil {
+		return err
+	}
+	if now.MerkleRoot != before.MerkleRoot || !now.CreatedAt.Equal(before.CreatedAt) {
+		return ErrObjectChanged
+	}
+
+	// The old chunks are moved aside so the new ones can take their place
+	// with a single rename
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return err
+	}
+	old := filepath.Join(s.Root, chunkedDirName, tmpPrefix+hex.EncodeToString(suffix[:]))
+	if err := os.Rename(s.objectDir(fullPath), old); err != nil {
+		return err
+	}
+	if err := os.Rename(stage, s.objectDir(fullPath)); err != nil {
+		_ = os.Rename(old, s.objectDir(fullPath))
+		return err
+	}
+	return os.RemoveAll(old)
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func upper(r io.Reader, w io.Writer) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bytes.ToUpper(data))
+	return err
+}
+
+func TestRewriteObject(t *testing.T) {
+	s := newMigrationStore(t)
+	_, err := s.Write("legacy", bytes.NewReader([]byte("legacy object")))
+	require.NoError(t, err)
+	require.NoError(t, s.setFormat(FormatChunked))
+	_, err = s.Write("chunked", bytes.NewReader([]byte("an object spanning chunks")))
+	require.NoError(t, err)
+
+	paths, err := s.Objects()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		CASPathTransformFunc("legacy").FullPath(),
+		CASPathTransformFunc("chunked").FullPath(),
+	}, paths)
+
+	for _, fullPath := range paths {
+		require.NoError(t, s.RewriteObject(fullPath, upper))
+	}
+	assert.Equal(t, []byte("LEGACY OBJECT"), readAll(t, s, "legacy"))
+	assert.Equal(t, []byte("AN OBJECT SPANNING CHUNKS"), readAll(t, s, "chunked"))
+
+	_, r, err := s.ReadObject(CASPathTransformFunc("chunked").FullPath())
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("AN OBJECT SPANNING CHUNKS"), data)
+
+	// Staging directories are gone
+	after, err := s.Objects()
+	require.NoError(t, err)
+	assert.Equal(t, paths, after)
+}
+
+func TestRewriteObject_ConcurrentChanges(t *testing.T) {
+	s := newMigrationStore(t)
+	for _, format := range []FormatVersion{FormatCAS, FormatChunked} {
+		require.NoError(t, s.setFormat(format))
+		key := "object-" + format.String()
+		fullPath := CASPathTransformFunc(key).FullPath()
+		_, err := s.Write(key, bytes.NewReader([]byte("old")))
+		require.NoError(t, err)
+
+		// Written again while being rewritten
+		err = s.RewriteObject(fullPath, func(r io.Reader, w io.Writer) error {
+			err := upper(r, w)
+			require.NoError(t, s.Delete(key))
+			_, writeErr := s.Write(key, bytes.NewReader([]byte("new")))
+			require.NoError(t, writeErr)
+			return err
+		})
+		assert.ErrorIs(t, err, ErrObjectChanged, format.String())
+		assert.Equal(t, []byte("new"), readAll(t, s, key))
+
+		// Deleted while being rewritten
+		err = s.RewriteObject(fullPath, func(r io.Reader, w io.Writer) error {
+			err := upper(r, w)
+			require.NoError(t, s.Delete(key))
+			return err
+		})
+		assert.ErrorIs(t, err, os.ErrNotExist, format.String())
+		assert.False(t, s.Has(key))
+	}
+}
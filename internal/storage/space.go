@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrSpaceUnsupported is returned by DiskSpace on platforms that cannot
+// report the free space of a filesystem
+var ErrSpaceUnsupported = errors.New("storage: free space is not reported on this platform")
+
+// Space is the size and free space of the filesystem holding a store
+type Space struct {
+	Total int64
+	// Free is the space available to the node, without blocks reserved for
+	// the superuser
+	Free int64
+}
+
+// DiskSpace returns the space of the filesystem the store's root is on, or
+// would be created on
+func (s *Store) DiskSpace() (Space, error) {
+	dir, err := filepath.Abs(s.Root)
+	if err != nil {
+		return Space{}, err
+	}
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return diskSpace(dir)
+}
+
+// Usage returns the size of the files under the store's root
+func (s *Store) Usage() (int64, error) {
+	var used int64
+	err := filepath.WalkDir(s.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		used += info.Size()
+		return nil
+	})
+	return used, err
+}
@@ -0,0 +1,7 @@
+//go:build !unix && !windows
+
+package storage
+
+func diskSpace(dir string) (Space, error) {
+	return Space{}, ErrSpaceUnsupported
+}
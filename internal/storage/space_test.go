@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsage(t *testing.T) {
+	s := NewStore(StoreOpts{Root: filepath.Join(t.TempDir(), "root"), PathTransformFunc: CASPathTransformFunc})
+	used, err := s.Usage()
+	require.NoError(t, err, "a store without a root yet is empty")
+	assert.Zero(t, used)
+
+	_, err = s.Write("a", bytes.NewReader(make([]byte, 1000)))
+	require.NoError(t, err)
+	_, err = s.Write("b", bytes.NewReader(make([]byte, 500)))
+	require.NoError(t, err)
+	used, err = s.Usage()
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, used, int64(1500))
+}
+
+func TestDiskSpace(t *testing.T) {
+	// The root does not exist yet: the filesystem it would be created on
+	// is measured
+	s := NewStore(StoreOpts{Root: filepath.Join(t.TempDir(), "missing", "root")})
+	space, err := s.DiskSpace()
+	if errors.Is(err, ErrSpaceUnsupported) {
+		t.Skip(err)
+	}
+	require.NoError(t, err)
+	assert.Positive(t, space.Total)
+	assert.LessOrEqual(t, space.Free, space.Total)
+}
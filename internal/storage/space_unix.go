@@ -0,0 +1,13 @@
+//go:build unix
+
+package storage
+
+import "golang.org/x/sys/unix"
+
+func diskSpace(dir string) (Space, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return Space{}, err
+	}
+	return Space{Total: int64(st.Blocks) * int64(st.Bsize), Free: int64(st.Bavail) * int64(st.Bsize)}, nil
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package storage
+
+import "golang.org/x/sys/windows"
+
+func diskSpace(dir string) (Space, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return Space{}, err
+	}
+	var free, total, totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &free, &total, &totalFree); err != nil {
+		return Space{}, err
+	}
+	return Space{Total: int64(total), Free: int64(free)}, nil
+}
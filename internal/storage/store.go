@@ -1,8 +1,6 @@
 package storage
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -10,21 +8,14 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 const defaultRootFolderName = "ggnetwork"
 
 func CASPathTransformFunc(key string) PathKey {
-	hash := sha1.Sum([]byte(key))
-	hashStr := hex.EncodeToString(hash[:])
-	blocksize := 5
-	sliceLen := len(hashStr) / blocksize
-	paths := make([]string, sliceLen)
-	for i := 0; i < sliceLen; i++ {
-		from, to := i*blocksize, (i*blocksize)+blocksize
-		paths[i] = hashStr[from:to]
-	}
-	return PathKey{PathName: strings.Join(paths, "/"), Filename: hashStr}
+	return CASPathTransform(HashSHA1)(key)
 }
 
 type PathTransformFunc func(string) PathKey
@@ -48,15 +39,38 @@ type StoreOpts struct {
 	// Root is the folder name of the root, containing all the folders/files of the system.
 	Root              string
 	PathTransformFunc PathTransformFunc
+	// ChunkSize is the chunk size used once the store is on the chunked format
+	ChunkSize int
+	// Hash hashes the chunks of new objects and, without a
+	// PathTransformFunc, names CAS paths. A store switched to another
+	// algorithm keeps finding objects under the paths of the ones before.
+	Hash HashAlgorithm
+	// OnViolation, when set, is called with the invariants an operation
+	// left broken: writes and deletes check the object they touched, GC
+	// the whole store. Checks read every chunk of the objects, so this is
+	// meant for tests and debugging.
+	OnViolation func(op string, violations []Violation)
 }
 
 var DefaultPathTransformFunc = func(key string) PathKey {
 	return PathKey{PathName: key, Filename: key}
 }
 
-type Store struct{ StoreOpts }
+type Store struct {
+	StoreOpts
+
+	// mu serializes moving objects between layouts against deletes
+	mu       sync.Mutex
+	format   atomic.Int32
+	inflight sync.Map // full path -> struct{} for legacy writes in progress
+	staging  sync.Map // staging dir -> struct{} for the ones in use
+	hash     HashAlgorithm
+	previous []previousHash // most recent first
+	moved    atomic.Int64
+}
 
 func NewStore(opts StoreOpts) *Store {
+	hashPaths := opts.PathTransformFunc == nil && opts.Hash != ""
 	if opts.PathTransformFunc == nil {
 		opts.PathTransformFunc = DefaultPathTransformFunc
 	}
@@ -64,17 +78,27 @@ func NewStore(opts StoreOpts) *Store {
 		opts.Root = defaultRootFolderName
 	}
 
-	// Ensure the root path is Windows-safe
-	opts.Root = DefaultPathSanitizer.SanitizePath(opts.Root)
+	// Ensure the root path is Windows-safe. Absolute roots were chosen by
+	// the operator and are kept, rather than moved under the working
+	// directory.
+	if !filepath.IsAbs(opts.Root) {
+		opts.Root = DefaultPathSanitizer.SanitizePath(opts.Root)
+	}
 
-	return &Store{StoreOpts: opts}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+
+	s := &Store{StoreOpts: opts, hash: opts.Hash}
+	if hashPaths {
+		s.initHash(opts.Hash)
+	}
+	s.format.Store(int32(s.loadFormat()))
+	return s
 }
 
 func (s *Store) Has(key string) bool {
-	pathKey := s.PathTransformFunc(key)
-	fullPathWithRoot := fmt.Sprintf("%s/%s", s.Root, pathKey.FullPath())
-	_, err := os.Stat(fullPathWithRoot)
-	return !errors.Is(err, os.ErrNotExist)
+	return s.exists(s.locate(key))
 }
 
 func (s *Store) Clear() error { return os.RemoveAll(s.Root) }
@@ -82,13 +106,56 @@ func (s *Store) Clear() error { return os.RemoveAll(s.Root) }
 func (s *Store) Delete(key string) error {
 	pathKey := s.PathTransformFunc(key)
 	defer func() { slog.Info("deleted", slog.String("key", pathKey.Filename)) }()
-	firstPathNameWithRoot := fmt.Sprintf("%s/%s", s.Root, pathKey.FirstPathName())
-	return os.RemoveAll(firstPathNameWithRoot)
+	pathKeys := []PathKey{pathKey}
+	for _, p := range s.previous {
+		pathKeys = append(pathKeys, p.transform(key))
+	}
+
+	fullPaths := make([]string, len(pathKeys))
+	for i, pathKey := range pathKeys {
+		fullPaths[i] = pathKey.FullPath()
+	}
+	defer s.verify("delete", fullPaths...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, pathKey := range pathKeys {
+		if err := os.RemoveAll(s.objectDir(pathKey.FullPath())); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(fmt.Sprintf("%s/%s", s.Root, pathKey.FirstPathName())); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (s *Store) Write(key string, r io.Reader) (int64, error) { return s.writeStream(key, r) }
+func (s *Store) Write(key string, r io.Reader) (int64, error) {
+	n, err := s.writeStream(key, r)
+	if err == nil {
+		s.verify("write", s.PathTransformFunc(key).FullPath())
+	}
+	return n, err
+}
 
 func (s *Store) WriteDecrypt(copyDecrypt func([]byte, io.Reader, io.Writer) (int, error), encKey []byte, key string, r io.Reader) (int64, error) {
+	n, err := s.writeDecrypt(copyDecrypt, encKey, key, r)
+	if err == nil {
+		s.verify("write", s.PathTransformFunc(key).FullPath())
+	}
+	return n, err
+}
+
+func (s *Store) writeDecrypt(copyDecrypt func([]byte, io.Reader, io.Writer) (int, error), encKey []byte, key string, r io.Reader) (int64, error) {
+	if s.Format() == FormatChunked {
+		return s.writeChunked(s.PathTransformFunc(key).FullPath(), func(w io.Writer) (int64, error) {
+			n, err := copyDecrypt(encKey, r, w)
+			return int64(n), err
+		})
+	}
+
+	done := s.beginLegacyWrite(key)
+	defer done()
 	f, err := s.openFileForWriting(key)
 	if err != nil {
 		return 0, err
@@ -133,7 +200,28 @@ func (s *Store) openFileForWriting(key string) (*os.File, error) {
 	return s.createFileAtomic(fullPathWithRoot)
 }
 
+// beginLegacyWrite marks a CAS file as being written so the migrator leaves
+// it alone until the write completes
+func (s *Store) beginLegacyWrite(key string) func() {
+	fullPath := s.PathTransformFunc(key).FullPath()
+	s.inflight.Store(fullPath, struct{}{})
+	return func() { s.inflight.Delete(fullPath) }
+}
+
+func (s *Store) writing(fullPath string) bool {
+	_, ok := s.inflight.Load(fullPath)
+	return ok
+}
+
 func (s *Store) writeStream(key string, r io.Reader) (int64, error) {
+	if s.Format() == FormatChunked {
+		return s.writeChunked(s.PathTransformFunc(key).FullPath(), func(w io.Writer) (int64, error) {
+			return io.Copy(w, r)
+		})
+	}
+
+	done := s.beginLegacyWrite(key)
+	defer done()
 	f, err := s.openFileForWriting(key)
 	if err != nil {
 		return 0, err
@@ -149,9 +237,21 @@ func (s *Store) writeStream(key string, r io.Reader) (int64, error) {
 func (s *Store) Read(key string) (int64, io.ReadCloser, error) { return s.readStream(key) }
 
 func (s *Store) readStream(key string) (int64, io.ReadCloser, error) {
-	pathKey := s.PathTransformFunc(key)
-	fullPathWithRoot := fmt.Sprintf("%s/%s", s.Root, pathKey.FullPath())
+	fullPath := s.locate(key)
+	if fullPath != s.PathTransformFunc(key).FullPath() {
+		// Found under a previous hash; move it under the current one
+		if moved, err := s.Rehash(key); err != nil {
+			slog.Warn("failed to move object to the current hash", "key", key, "error", err)
+		} else if moved {
+			fullPath = s.PathTransformFunc(key).FullPath()
+		}
+	}
+	fullPathWithRoot := s.legacyPath(fullPath)
 	file, err := os.Open(fullPathWithRoot)
+	if errors.Is(err, os.ErrNotExist) && s.hasChunked(fullPath) {
+		// The CAS file stays authoritative until a migration is finalized
+		return s.readChunked(fullPath)
+	}
 	if err != nil {
 		return 0, nil, err
 	}
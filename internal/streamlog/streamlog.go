@@ -0,0 +1,632 @@
+// Package streamlog keeps append-only logs of records, for telemetry,
+// audit events and event sourcing. A log is stored as segment objects and
+// an index object in the node's object store, so it is replicated like any
+// other object. Records are read by offset or time and dropped from the
+// front by retention.
+package streamlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Prefix is the key prefix the objects of logs are stored under
+const Prefix = ".logs/"
+
+// Defaults of Config
+const (
+	DefaultSegmentBytes = 256 << 10
+	DefaultReadLimit    = 1000
+	// ExpireInterval is how often Start applies retention to idle logs
+	ExpireInterval = time.Minute
+	// MaxRecordSize is the largest record in bytes
+	MaxRecordSize = 1 << 20
+)
+
+var (
+	// ErrNotFound is returned for logs that do not exist
+	ErrNotFound = errors.New("streamlog: log not found")
+	// ErrInvalidName is returned for names that cannot name a log
+	ErrInvalidName = errors.New("streamlog: invalid log name")
+	// ErrTooLarge is returned for records above MaxRecordSize
+	ErrTooLarge = errors.New("streamlog: record too large")
+)
+
+// Store keeps the objects of logs
+type Store interface {
+	Has(key string) bool
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, data []byte) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Config sets how logs are cut into segments and how long records are kept
+type Config struct {
+	// SegmentBytes is the size at which a segment is sealed and a new one
+	// started; DefaultSegmentBytes when zero. The open segment is
+	// rewritten on every append.
+	SegmentBytes int64 `yaml:"segment_bytes" json:"segment_bytes"`
+	// MaxAge drops segments whose newest record is older; zero keeps
+	// records forever
+	MaxAge time.Duration `yaml:"max_age" json:"max_age"`
+	// MaxBytes drops the oldest segments while a log is larger; zero does
+	// not limit the size
+	MaxBytes int64 `yaml:"max_bytes" json:"max_bytes"`
+}
+
+// Record is one entry of a log
+type Record struct {
+	Offset uint64    `json:"offset"`
+	Time   time.Time `json:"time"`
+	Data   []byte    `json:"data,omitempty"`
+}
+
+// Info describes a log
+type Info struct {
+	Name string `json:"name"`
+	// StartOffset is the oldest offset still stored, NextOffset the one the
+	// next record gets
+	StartOffset uint64    `json:"start_offset"`
+	NextOffset  uint64    `json:"next_offset"`
+	Records     uint64    `json:"records"`
+	Bytes       int64     `json:"bytes"`
+	Segments    int       `json:"segments"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at,omitzero"`
+}
+
+// Query selects records to read
+type Query struct {
+	// Offset is the first offset to read
+	Offset uint64
+	// Since skips records older than this time
+	Since time.Time
+	// Limit caps the records returned; DefaultReadLimit when zero
+	Limit int
+}
+
+// segment is a run of records stored in one object
+type segment struct {
+	First     uint64    `json:"first"`
+	Count     uint64    `json:"count"`
+	Bytes     int64     `json:"bytes"`
+	FirstTime time.Time `json:"first_time"`
+	LastTime  time.Time `json:"last_time"`
+}
+
+// index is the stored state of a log
+type index struct {
+	Name       string    `json:"name"`
+	NextOffset uint64    `json:"next_offset"`
+	CreatedAt  time.Time `json:"created_at"`
+	Segments   []segment `json:"segments"`
+}
+
+func (x *index) info() Info {
+	info := Info{Name: x.Name, StartOffset: x.NextOffset, NextOffset: x.NextOffset, Segments: len(x.Segments), CreatedAt: x.CreatedAt}
+	if len(x.Segments) > 0 {
+		info.StartOffset = x.Segments[0].First
+		info.UpdatedAt = x.Segments[len(x.Segments)-1].LastTime
+	}
+	for _, s := range x.Segments {
+		info.Records += s.Count
+		info.Bytes += s.Bytes
+	}
+	return info
+}
+
+// log is an open log; appends hold mu, and changed is closed and replaced
+// after each one to wake followers
+type log struct {
+	mu      sync.Mutex
+	index   *index
+	changed chan struct{}
+	deleted bool
+}
+
+var nameRE = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// ValidName reports whether name can name a log
+func ValidName(name string) bool {
+	return nameRE.MatchString(name)
+}
+
+func indexKey(name string) string { return Prefix + name + "/index" }
+
+// SegmentKey is the key of the segment of a log starting at first
+func SegmentKey(name string, first uint64) string {
+	return fmt.Sprintf("%s%s/%020d", Prefix, name, first)
+}
+
+const catalogKey = Prefix + "catalog"
+
+// Manager appends to and reads the logs of a store. Each log should be
+// written through one Manager at a time, as the open segment is rewritten
+// in place.
+type Manager struct {
+	cfg   Config
+	store Store
+	now   func() time.Time
+
+	mu   sync.Mutex
+	logs map[string]*log
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a manager of the logs kept in store
+func New(cfg Config, store Store) *Manager {
+	if cfg.SegmentBytes <= 0 {
+		cfg.SegmentBytes = DefaultSegmentBytes
+	}
+	return &Manager{cfg: cfg, store: store, now: time.Now, logs: make(map[string]*log)}
+}
+
+// open returns the log called name, loading it from the store; create
+// starts a new log when there is none
+func (m *Manager) open(ctx context.Context, name string, create bool) (*log, error) {
+	if !ValidName(name) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l, ok := m.logs[name]; ok {
+		return l, nil
+	}
+
+	x := &index{}
+	if m.store.Has(indexKey(name)) {
+		data, err := m.store.Get(ctx, indexKey(name))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, x); err != nil {
+			return nil, fmt.Errorf("streamlog: corrupt index of %s: %w", name, err)
+		}
+	} else {
+		if !create {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+		}
+		x = &index{Name: name, CreatedAt: m.now().UTC()}
+		if err := m.putIndex(ctx, x); err != nil {
+			return nil, err
+		}
+		if err := m.updateCatalog(ctx, func(names []string) []string { return append(names, name) }); err != nil {
+			return nil, err
+		}
+	}
+	l := &log{index: x, changed: make(chan struct{})}
+	m.logs[name] = l
+	return l, nil
+}
+
+func (m *Manager) putIndex(ctx context.Context, x *index) error {
+	data, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+	return m.store.Put(ctx, indexKey(x.Name), data)
+}
+
+// names returns the logs of the catalog. The caller holds m.mu.
+func (m *Manager) names(ctx context.Context) ([]string, error) {
+	if !m.store.Has(catalogKey) {
+		return nil, nil
+	}
+	data, err := m.store.Get(ctx, catalogKey)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("streamlog: corrupt catalog: %w", err)
+	}
+	return names, nil
+}
+
+// updateCatalog rewrites the list of logs. The caller holds m.mu.
+func (m *Manager) updateCatalog(ctx context.Context, change func([]string) []string) error {
+	names, err := m.names(ctx)
+	if err != nil {
+		return err
+	}
+	names = change(names)
+	slices.Sort(names)
+	data, err := json.Marshal(slices.Compact(names))
+	if err != nil {
+		return err
+	}
+	return m.store.Put(ctx, catalogKey, data)
+}
+
+// Append adds records to the end of a log, creating the log if needed, and
+// returns them with their offsets and times
+func (m *Manager) Append(ctx context.Context, name string, data ...[]byte) ([]Record, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	for _, d := range data {
+		if len(d) > MaxRecordSize {
+			return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, len(d))
+		}
+	}
+	l, err := m.open(ctx, name, true)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Times never go backwards within a log, so time queries can skip
+	// whole segments
+	x := l.index
+	now := m.now().UTC()
+	if n := len(x.Segments); n > 0 && now.Before(x.Segments[n-1].LastTime) {
+		now = x.Segments[n-1].LastTime
+	}
+
+	records := make([]Record, len(data))
+	for i, d := range data {
+		records[i] = Record{Offset: x.NextOffset + uint64(i), Time: now, Data: d}
+	}
+
+	next := *x
+	next.Segments = slices.Clone(x.Segments)
+	var open []Record
+	if n := len(next.Segments); n > 0 && next.Segments[n-1].Bytes < m.cfg.SegmentBytes {
+		if open, err = m.readSegment(ctx, name, next.Segments[n-1]); err != nil {
+			return nil, err
+		}
+		next.Segments = next.Segments[:n-1]
+	}
+
+	// Fill the open segment, sealing it and starting another whenever it
+	// reaches the segment size. Should a write fail, readers ignore the
+	// records the rewritten open segment gained, and the segments started
+	// here are dropped.
+	var started []string
+	reopened := open != nil
+	pending := records
+	for len(pending) > 0 {
+		var size int64
+		for _, r := range open {
+			size += recordSize(r)
+		}
+		for len(pending) > 0 && (size < m.cfg.SegmentBytes || len(open) == 0) {
+			size += recordSize(pending[0])
+			open, pending = append(open, pending[0]), pending[1:]
+		}
+		seg, err := m.writeSegment(ctx, name, open)
+		if err != nil {
+			m.dropObjects(ctx, started)
+			return nil, err
+		}
+		if !reopened {
+			started = append(started, SegmentKey(name, seg.First))
+		}
+		reopened = false
+		next.Segments = append(next.Segments, seg)
+		open = nil
+	}
+	next.NextOffset += uint64(len(records))
+
+	dropped := m.retain(&next, now)
+	if err := m.putIndex(ctx, &next); err != nil {
+		m.dropObjects(ctx, started)
+		return nil, err
+	}
+	*x = next
+	m.dropSegments(ctx, name, dropped)
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return records, nil
+}
+
+func recordSize(r Record) int64 {
+	return int64(len(r.Data)) + 64
+}
+
+// writeSegment stores records as one segment, a JSON record per line
+func (m *Manager) writeSegment(ctx context.Context, name string, records []Record) (segment, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	seg := segment{First: records[0].Offset, Count: uint64(len(records)), FirstTime: records[0].Time, LastTime: records[len(records)-1].Time}
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return segment{}, err
+		}
+		seg.Bytes += recordSize(r)
+	}
+	return seg, m.store.Put(ctx, SegmentKey(name, seg.First), buf.Bytes())
+}
+
+func (m *Manager) readSegment(ctx context.Context, name string, seg segment) ([]Record, error) {
+	data, err := m.store.Get(ctx, SegmentKey(name, seg.First))
+	if err != nil {
+		return nil, err
+	}
+	records := make([]Record, 0, seg.Count)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var r Record
+		if err := dec.Decode(&r); err != nil {
+			return nil, fmt.Errorf("streamlog: corrupt segment %s: %w", SegmentKey(name, seg.First), err)
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// retain drops the segments retention no longer keeps from x, always
+// keeping the newest, and returns them
+func (m *Manager) retain(x *index, now time.Time) []segment {
+	var total int64
+	for _, s := range x.Segments {
+		total += s.Bytes
+	}
+	n := 0
+	for n < len(x.Segments)-1 {
+		s := x.Segments[n]
+		expired := m.cfg.MaxAge > 0 && now.Sub(s.LastTime) > m.cfg.MaxAge
+		oversize := m.cfg.MaxBytes > 0 && total > m.cfg.MaxBytes
+		if !expired && !oversize {
+			break
+		}
+		total -= s.Bytes
+		n++
+	}
+	dropped := slices.Clone(x.Segments[:n])
+	x.Segments = x.Segments[n:]
+	return dropped
+}
+
+func (m *Manager) dropSegments(ctx context.Context, name string, segments []segment) {
+	keys := make([]string, len(segments))
+	for i, s := range segments {
+		keys[i] = SegmentKey(name, s.First)
+	}
+	m.dropObjects(ctx, keys)
+}
+
+// dropObjects deletes objects no index refers to any more; a failure only
+// leaves garbage behind
+func (m *Manager) dropObjects(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		_ = m.store.Delete(ctx, key)
+	}
+}
+
+// Read returns records of a log from q.Offset on, oldest first. Reading
+// from an offset retention dropped starts at the oldest record kept, so
+// readers see the gap in the offsets.
+func (m *Manager) Read(ctx context.Context, name string, q Query) ([]Record, error) {
+	l, err := m.open(ctx, name, false)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	x := *l.index
+	l.mu.Unlock()
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultReadLimit
+	}
+
+	var records []Record
+	for _, seg := range x.Segments {
+		if seg.First+seg.Count <= q.Offset || seg.LastTime.Before(q.Since) {
+			continue
+		}
+		batch, err := m.readSegment(ctx, name, seg)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range batch {
+			if r.Offset < q.Offset || r.Offset >= x.NextOffset || r.Time.Before(q.Since) {
+				continue
+			}
+			records = append(records, r)
+			if len(records) == limit {
+				return records, nil
+			}
+		}
+	}
+	return records, nil
+}
+
+// Wait blocks until a log has a record at offset, or ctx is done
+func (m *Manager) Wait(ctx context.Context, name string, offset uint64) error {
+	l, err := m.open(ctx, name, false)
+	if err != nil {
+		return err
+	}
+	for {
+		l.mu.Lock()
+		ready, deleted, changed := l.index.NextOffset > offset, l.deleted, l.changed
+		l.mu.Unlock()
+		if deleted {
+			return fmt.Errorf("%w: %s", ErrNotFound, name)
+		}
+		if ready {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Info describes a log
+func (m *Manager) Info(ctx context.Context, name string) (Info, error) {
+	l, err := m.open(ctx, name, false)
+	if err != nil {
+		return Info{}, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.index.info(), nil
+}
+
+// List describes every log
+func (m *Manager) List(ctx context.Context) ([]Info, error) {
+	m.mu.Lock()
+	names, err := m.names(ctx)
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]Info, 0, len(names))
+	for _, name := range names {
+		info, err := m.Info(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Truncate drops the records of a log before offset and those older than
+// before, where they fill whole segments. The newest segment is kept.
+func (m *Manager) Truncate(ctx context.Context, name string, offset uint64, before time.Time) (Info, error) {
+	l, err := m.open(ctx, name, false)
+	if err != nil {
+		return Info{}, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	x := l.index
+	n := 0
+	for n < len(x.Segments)-1 {
+		s := x.Segments[n]
+		if s.First+s.Count > offset && !s.LastTime.Before(before) {
+			break
+		}
+		n++
+	}
+	if n == 0 {
+		return x.info(), nil
+	}
+	next := *x
+	next.Segments = slices.Clone(x.Segments[n:])
+	if err := m.putIndex(ctx, &next); err != nil {
+		return Info{}, err
+	}
+	dropped := x.Segments[:n]
+	*x = next
+	m.dropSegments(ctx, name, dropped)
+	return x.info(), nil
+}
+
+// Expire applies the retention of the configuration to every log, for
+// logs that are no longer appended to
+func (m *Manager) Expire(ctx context.Context) error {
+	infos, err := m.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		l, err := m.open(ctx, info.Name, false)
+		if err != nil {
+			continue
+		}
+		l.mu.Lock()
+		next := *l.index
+		next.Segments = slices.Clone(l.index.Segments)
+		dropped := m.retain(&next, m.now().UTC())
+		if len(dropped) > 0 {
+			if err = m.putIndex(ctx, &next); err == nil {
+				*l.index = next
+				m.dropSegments(ctx, info.Name, dropped)
+			}
+		}
+		l.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start applies retention to every log each ExpireInterval until Stop is
+// called. It does nothing without MaxAge, as sizes only grow by appends,
+// which apply retention themselves.
+func (m *Manager) Start() {
+	m.mu.Lock()
+	if m.stop != nil || m.cfg.MaxAge <= 0 {
+		m.mu.Unlock()
+		return
+	}
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	stop, done := m.stop, m.done
+	m.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(ExpireInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				_ = m.Expire(context.Background())
+			}
+		}
+	}()
+}
+
+// Stop stops applying retention
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	stop, done := m.stop, m.done
+	m.stop, m.done = nil, nil
+	m.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Delete removes a log and its records
+func (m *Manager) Delete(ctx context.Context, name string) error {
+	l, err := m.open(ctx, name, false)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.updateCatalog(ctx, func(names []string) []string {
+		return slices.DeleteFunc(names, func(n string) bool { return n == name })
+	}); err != nil {
+		return err
+	}
+	if err := m.store.Delete(ctx, indexKey(name)); err != nil {
+		return err
+	}
+	m.dropSegments(ctx, name, l.index.Segments)
+	delete(m.logs, name)
+	// Wake followers, who then find the log gone
+	l.deleted = true
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return nil
+}
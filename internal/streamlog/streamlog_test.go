@@ -0,0 +1,260 @@
+package streamlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore is a Store in memory
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemStore() *memStore { return &memStore{objects: make(map[string][]byte)} }
+
+func (s *memStore) Has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.objects[key]
+	return ok
+}
+
+func (s *memStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return data, nil
+}
+
+func (s *memStore) Put(ctx context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	return nil
+}
+
+func (s *memStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *memStore) keys(prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// clock is a settable time for retention tests
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func payloads(records []Record) []string {
+	out := make([]string, len(records))
+	for i, r := range records {
+		out[i] = string(r.Data)
+	}
+	return out
+}
+
+func TestAppendAndRead(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	// Segments of about three records
+	m := New(Config{SegmentBytes: 3 * 65}, store)
+
+	records, err := m.Append(ctx, "telemetry", []byte("r0"), []byte("r1"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), records[0].Offset)
+	assert.Equal(t, uint64(1), records[1].Offset)
+	for i := 2; i < 8; i++ {
+		_, err := m.Append(ctx, "telemetry", fmt.Appendf(nil, "r%d", i))
+		require.NoError(t, err)
+	}
+
+	info, err := m.Info(ctx, "telemetry")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), info.StartOffset)
+	assert.Equal(t, uint64(8), info.NextOffset)
+	assert.Equal(t, uint64(8), info.Records)
+	assert.Equal(t, 3, info.Segments)
+	assert.Len(t, store.keys(Prefix+"telemetry/0"), 3)
+
+	got, err := m.Read(ctx, "telemetry", Query{Offset: 2, Limit: 4})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"r2", "r3", "r4", "r5"}, payloads(got))
+	got, err = m.Read(ctx, "telemetry", Query{Offset: 6})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"r6", "r7"}, payloads(got))
+	got, err = m.Read(ctx, "telemetry", Query{Offset: 8})
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	// A new manager reads what the first one stored
+	reopened, err := New(Config{}, store).Read(ctx, "telemetry", Query{})
+	require.NoError(t, err)
+	assert.Len(t, reopened, 8)
+
+	_, err = m.Read(ctx, "missing", Query{})
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = m.Append(ctx, "../escape", []byte("x"))
+	assert.ErrorIs(t, err, ErrInvalidName)
+	_, err = m.Append(ctx, "telemetry", make([]byte, MaxRecordSize+1))
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	infos, err := m.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "telemetry", infos[0].Name)
+}
+
+func TestReadSince(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m := New(Config{SegmentBytes: 2 * 65}, newMemStore())
+	m.now = c.now
+
+	for i := range 6 {
+		_, err := m.Append(ctx, "audit", fmt.Appendf(nil, "e%d", i))
+		require.NoError(t, err)
+		c.t = c.t.Add(time.Minute)
+	}
+
+	got, err := m.Read(ctx, "audit", Query{Since: time.Date(2026, 1, 1, 0, 3, 0, 0, time.UTC)})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"e3", "e4", "e5"}, payloads(got))
+
+	// Times never go backwards, even when the clock does
+	c.t = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	records, err := m.Append(ctx, "audit", []byte("late"))
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 1, 1, 0, 5, 0, 0, time.UTC), records[0].Time)
+}
+
+func TestRetention(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := newMemStore()
+	m := New(Config{SegmentBytes: 2 * 65, MaxAge: time.Hour}, store)
+	m.now = c.now
+
+	for i := range 4 {
+		_, err := m.Append(ctx, "events", fmt.Appendf(nil, "old%d", i))
+		require.NoError(t, err)
+	}
+	c.t = c.t.Add(2 * time.Hour)
+	_, err := m.Append(ctx, "events", []byte("new"))
+	require.NoError(t, err)
+
+	info, err := m.Info(ctx, "events")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), info.StartOffset, "expired segments are dropped")
+	assert.Len(t, store.keys(Prefix+"events/0"), 1)
+
+	got, err := m.Read(ctx, "events", Query{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new"}, payloads(got), "reads start at the oldest record kept")
+	assert.Equal(t, uint64(4), got[0].Offset)
+
+	// Expire applies retention to logs no longer appended to, keeping the
+	// newest segment
+	c.t = c.t.Add(2 * time.Hour)
+	require.NoError(t, m.Expire(ctx))
+	info, err = m.Info(ctx, "events")
+	require.NoError(t, err)
+	assert.Equal(t, 1, info.Segments)
+
+	sized := New(Config{SegmentBytes: 65, MaxBytes: 3 * 66}, newMemStore())
+	for i := range 6 {
+		_, err := sized.Append(ctx, "metrics", fmt.Appendf(nil, "m%d", i))
+		require.NoError(t, err)
+	}
+	info, err = sized.Info(ctx, "metrics")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), info.StartOffset)
+	assert.LessOrEqual(t, info.Bytes, int64(3*66))
+}
+
+func TestTruncateAndDelete(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	m := New(Config{SegmentBytes: 2 * 65}, store)
+	for i := range 6 {
+		_, err := m.Append(ctx, "orders", fmt.Appendf(nil, "o%d", i))
+		require.NoError(t, err)
+	}
+
+	info, err := m.Truncate(ctx, "orders", 3, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), info.StartOffset, "only whole segments are dropped")
+	info, err = m.Truncate(ctx, "orders", 100, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), info.StartOffset, "the newest segment is kept")
+
+	require.NoError(t, m.Delete(ctx, "orders"))
+	assert.Empty(t, store.keys(Prefix+"orders/"))
+	_, err = m.Info(ctx, "orders")
+	assert.ErrorIs(t, err, ErrNotFound)
+	infos, err := m.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, infos)
+}
+
+func TestWait(t *testing.T) {
+	ctx := context.Background()
+	m := New(Config{}, newMemStore())
+	_, err := m.Append(ctx, "feed", []byte("first"))
+	require.NoError(t, err)
+	require.NoError(t, m.Wait(ctx, "feed", 0), "the record is there")
+
+	done := make(chan error, 1)
+	go func() { done <- m.Wait(ctx, "feed", 1) }()
+	select {
+	case <-done:
+		t.Fatal("Wait returned before the record was appended")
+	case <-time.After(20 * time.Millisecond):
+	}
+	_, err = m.Append(ctx, "feed", []byte("second"))
+	require.NoError(t, err)
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not return after the append")
+	}
+
+	go func() { done <- m.Wait(ctx, "feed", 5) }()
+	require.NoError(t, m.Delete(ctx, "feed"))
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, ErrNotFound)
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not return after the delete")
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = m.Append(ctx, "feed", []byte("again"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, m.Wait(timeout, "feed", 1), context.DeadlineExceeded)
+}
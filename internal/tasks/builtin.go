@@ -0,0 +1,112 @@
+package tasks
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/internal/plugins"
+)
+
+// HashCheck writes the hex SHA-256 of its input. With a sha256 argument,
+// it fails unless the input has that digest, so callers verify data where
+// it is without fetching it.
+var HashCheck = HandlerFunc(func(ctx context.Context, args map[string]string, in io.Reader, out io.Writer) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, in); err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if want := strings.ToLower(args["sha256"]); want != "" && want != sum {
+		return fmt.Errorf("digest mismatch: got %s, want %s", sum, want)
+	}
+	_, err := io.WriteString(out, sum)
+	return err
+})
+
+// Compress gzips its input at the level argument, 1 to 9, or the default
+// level
+var Compress = HandlerFunc(func(ctx context.Context, args map[string]string, in io.Reader, out io.Writer) error {
+	level := gzip.DefaultCompression
+	if arg := args["level"]; arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < gzip.BestSpeed || n > gzip.BestCompression {
+			return fmt.Errorf("invalid level %q: want 1 to 9", arg)
+		}
+		level = n
+	}
+	zw, err := gzip.NewWriterLevel(out, level)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(zw, in); err != nil {
+		return err
+	}
+	return zw.Close()
+})
+
+// Decompress gunzips its input
+var Decompress = HandlerFunc(func(ctx context.Context, args map[string]string, in io.Reader, out io.Writer) error {
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, zr); err != nil {
+		return err
+	}
+	return zr.Close()
+})
+
+// RegisterBuiltins registers hash-check, compress and decompress
+func RegisterBuiltins(h *Host) error {
+	for name, handler := range map[string]Handler{
+		"hash-check": HashCheck,
+		"compress":   Compress,
+		"decompress": Decompress,
+	} {
+		if err := h.Register(name, handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Plugin runs a processing plugin as a handler, passing the arguments of
+// calls as its configuration
+func Plugin(p plugins.ProcessingPlugin) Handler {
+	return HandlerFunc(func(ctx context.Context, args map[string]string, in io.Reader, out io.Writer) error {
+		config := make(map[string]interface{}, len(args))
+		for k, v := range args {
+			config[k] = v
+		}
+		result, err := p.Process(ctx, in, config)
+		if err != nil {
+			return err
+		}
+		if closer, ok := result.(io.Closer); ok {
+			defer closer.Close()
+		}
+		_, err = io.Copy(out, result)
+		return err
+	})
+}
+
+// RegisterPlugins registers the processing plugins registered with the
+// plugins package, each under its name
+func RegisterPlugins(h *Host) error {
+	for _, name := range plugins.ListRegisteredPlugins()[plugins.PluginTypeProcessing] {
+		p, err := plugins.GetProcessingPlugin(name)
+		if err != nil {
+			return err
+		}
+		if err := h.Register(name, Plugin(p)); err != nil {
+			return fmt.Errorf("processing plugin %s: %w", name, err)
+		}
+	}
+	return nil
+}
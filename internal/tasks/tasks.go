@@ -0,0 +1,715 @@
+// Package tasks runs named handlers on behalf of other nodes. A node
+// registers handlers, such as the built-in hash-check and compress, and the
+// peers it authorizes invoke them remotely: the caller streams its input to
+// the node running the handler, which streams the output back as it is
+// produced. Both directions are flow controlled with credits, so neither
+// side buffers more than Window bytes of a call. Tasks is the substrate a
+// scheduler dispatches work onto; it neither picks nodes nor retries.
+package tasks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxConcurrent is how many calls of peers a node runs at once
+	DefaultMaxConcurrent = 16
+	// DefaultTimeout bounds how long a call of a peer runs
+	DefaultTimeout = 10 * time.Minute
+	// Window is how many bytes of a stream are sent ahead of what the
+	// receiver consumed
+	Window = 1 << 20
+	// ChunkSize is the largest piece of a stream sent in one message
+	ChunkSize = 32 << 10
+)
+
+var (
+	// ErrInvalid is returned for handler names that are malformed or taken
+	ErrInvalid = errors.New("tasks: invalid handler")
+	// ErrUnknownHandler is returned for calls of handlers the node did
+	// not register
+	ErrUnknownHandler = errors.New("tasks: unknown handler")
+	// ErrDenied is returned for calls of handlers the caller is not allowed
+	// to run
+	ErrDenied = errors.New("tasks: caller not allowed")
+	// ErrBusy is returned for calls while the node runs MaxConcurrent calls
+	ErrBusy = errors.New("tasks: too many calls")
+	// ErrFailed is returned for calls whose handler returned an error
+	ErrFailed = errors.New("tasks: handler failed")
+	// ErrCanceled ends the calls the caller gave up on
+	ErrCanceled = errors.New("tasks: call canceled")
+	// ErrUnreachable is returned by transports for nodes that are not
+	// connected, and for calls of nodes that disconnect
+	ErrUnreachable = errors.New("tasks: node unreachable")
+	// ErrProtocol ends calls whose peer sent more than its credit
+	ErrProtocol = errors.New("tasks: stream exceeded its window")
+	// ErrDetached is returned for calls made before the host is attached
+	// to a node
+	ErrDetached = errors.New("tasks: host not attached to a node")
+)
+
+// codes name the errors that end calls on the wire
+var codes = map[string]error{
+	"unknown":     ErrUnknownHandler,
+	"denied":      ErrDenied,
+	"busy":        ErrBusy,
+	"failed":      ErrFailed,
+	"canceled":    ErrCanceled,
+	"unreachable": ErrUnreachable,
+	"protocol":    ErrProtocol,
+}
+
+// codeOf returns the code of err, failed for errors of handlers
+func codeOf(err error) string {
+	for code, e := range codes {
+		if errors.Is(err, e) {
+			return code
+		}
+	}
+	return "failed"
+}
+
+// validName matches handler names, such as "hash-check" or "thumbnail.v2"
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// Handler runs a task: it reads the input of the call from in and writes
+// its output to out. Args are the parameters the caller passed.
+type Handler interface {
+	Run(ctx context.Context, args map[string]string, in io.Reader, out io.Writer) error
+}
+
+// HandlerFunc adapts a function to a Handler
+type HandlerFunc func(ctx context.Context, args map[string]string, in io.Reader, out io.Writer) error
+
+// Run calls f
+func (f HandlerFunc) Run(ctx context.Context, args map[string]string, in io.Reader, out io.Writer) error {
+	return f(ctx, args, in, out)
+}
+
+// Call asks a node to run one of its handlers; the input and output follow
+// as Data of the call
+type Call struct {
+	ID      string
+	Handler string
+	Args    map[string]string
+}
+
+// Data carries part of the input or output of a call
+type Data struct {
+	Call string
+	// ToHost is set on the data the caller sends, that is the input and
+	// the credit for the output
+	ToHost bool
+	Data   []byte
+	// Credit lets the receiver send that many more bytes
+	Credit int64
+	// Final ends the stream of the sender; with an Error, it ends the call
+	Final bool
+	Code  string
+	Error string
+}
+
+// Transport carries calls and their data to other nodes. Data of a call
+// must arrive in the order it was sent.
+type Transport interface {
+	// SendCall sends c to the node with the given ID, returning
+	// ErrUnreachable when it is not connected
+	SendCall(node string, c Call) error
+	// SendData sends d to the node with the given ID
+	SendData(node string, d Data) error
+}
+
+// Error is the error of a call that the node running it reported
+type Error struct {
+	Node    string
+	Handler string
+	// Err is the sentinel of the failure, such as ErrDenied or ErrFailed
+	Err     error
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s on %s: %s", e.Handler, e.Node, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Options configures a host
+type Options struct {
+	// Allow lists the IDs of the nodes allowed to run each handler, "*"
+	// allowing every peer. Handlers it does not list only run for this
+	// node.
+	Allow map[string][]string
+	// MaxConcurrent bounds the calls of peers running at once; zero uses
+	// DefaultMaxConcurrent
+	MaxConcurrent int
+	// Timeout bounds how long a call of a peer runs; zero uses
+	// DefaultTimeout
+	Timeout time.Duration
+}
+
+// call is a call this node made or runs
+type call struct {
+	id      string
+	node    string
+	handler string
+	// host is set on the calls this node runs
+	host bool
+	in   *stream
+	// cancel stops the handler of the calls this node runs
+	cancel context.CancelFunc
+	// abort tells the peer once that the call is over
+	abort sync.Once
+}
+
+// Host runs the handlers of a node for its peers and calls the handlers of
+// the peers
+type Host struct {
+	opts Options
+
+	mu        sync.Mutex
+	node      string
+	transport Transport
+	handlers  map[string]Handler
+	// hosted holds the calls this node runs by caller and ID, calls those
+	// it made by ID
+	hosted map[string]*call
+	calls  map[string]*call
+	// peers holds the handlers each peer advertised
+	peers map[string][]string
+}
+
+// New creates a host without handlers, which calls no peers until it is
+// attached
+func New(opts Options) *Host {
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = DefaultMaxConcurrent
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	return &Host{
+		opts:     opts,
+		handlers: make(map[string]Handler),
+		hosted:   make(map[string]*call),
+		calls:    make(map[string]*call),
+		peers:    make(map[string][]string),
+	}
+}
+
+// Attach makes the host call and answer peers as the node with the given
+// ID over t
+func (h *Host) Attach(node string, t Transport) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.node, h.transport = node, t
+}
+
+// Register adds a handler under a name of lowercase letters, digits, '.',
+// '-' and '_'
+func (h *Host) Register(name string, handler Handler) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("%w: malformed name %q", ErrInvalid, name)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.handlers[name]; ok {
+		return fmt.Errorf("%w: %s is already registered", ErrInvalid, name)
+	}
+	h.handlers[name] = handler
+	return nil
+}
+
+// Handlers returns the names of the registered handlers, sorted
+func (h *Host) Handlers() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	names := make([]string, 0, len(h.handlers))
+	for name := range h.handlers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Advertise records the handlers a peer registered
+func (h *Host) Advertise(node string, handlers []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(handlers) == 0 {
+		delete(h.peers, node)
+		return
+	}
+	h.peers[node] = slices.Clone(handlers)
+}
+
+// Nodes returns the IDs of the peers that advertised a handler, sorted.
+// Whether they let this node run it is up to them.
+func (h *Host) Nodes(handler string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var nodes []string
+	for node, handlers := range h.peers {
+		if slices.Contains(handlers, handler) {
+			nodes = append(nodes, node)
+		}
+	}
+	slices.Sort(nodes)
+	return nodes
+}
+
+// Peers returns the handlers each peer advertised
+func (h *Host) Peers() map[string][]string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	peers := make(map[string][]string, len(h.peers))
+	for node, handlers := range h.peers {
+		peers[node] = slices.Clone(handlers)
+	}
+	return peers
+}
+
+// DropNode forgets a peer that disconnected, failing the calls made to it
+// and canceling those it made
+func (h *Host) DropNode(node string) {
+	h.mu.Lock()
+	delete(h.peers, node)
+	var dropped []*call
+	for _, c := range h.calls {
+		if c.node == node {
+			dropped = append(dropped, c)
+		}
+	}
+	for _, c := range h.hosted {
+		if c.node == node {
+			dropped = append(dropped, c)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, c := range dropped {
+		if c.host {
+			c.in.end(fmt.Errorf("%w: %s disconnected", ErrCanceled, node))
+			c.cancel()
+			continue
+		}
+		c.in.end(&Error{Node: node, Handler: c.handler, Err: ErrUnreachable, Message: "node disconnected"})
+	}
+}
+
+// Invoke runs a handler of a node, streaming in to it and its output to
+// out. Calls of this node run the handler directly, without checking
+// Options.Allow. Like exec.Cmd with a Stdin, Invoke returns once it
+// stopped reading in, which is at EOF or once the call ended. Errors the
+// node reports are *Error.
+func (h *Host) Invoke(ctx context.Context, node, handler string, args map[string]string, in io.Reader, out io.Writer) error {
+	if in == nil {
+		in = eof{}
+	}
+	h.mu.Lock()
+	self, t := h.node, h.transport
+	if self == "" {
+		h.mu.Unlock()
+		return ErrDetached
+	}
+	if node == self {
+		run, ok := h.handlers[handler]
+		h.mu.Unlock()
+		if !ok {
+			return &Error{Node: node, Handler: handler, Err: ErrUnknownHandler, Message: "unknown handler"}
+		}
+		if err := run.Run(ctx, args, in, out); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return &Error{Node: node, Handler: handler, Err: ErrFailed, Message: err.Error()}
+		}
+		return nil
+	}
+	if t == nil {
+		h.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrUnreachable, node)
+	}
+	c := &call{id: newID(), node: node, handler: handler, in: newStream(false)}
+	h.calls[c.id] = c
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		delete(h.calls, c.id)
+		h.mu.Unlock()
+	}()
+
+	if err := t.SendCall(node, Call{ID: c.id, Handler: handler, Args: args}); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.sendInput(ctx, t, c, in)
+	}()
+	_, err := io.Copy(out, &reader{ctx: ctx, transport: t, call: c})
+	if err != nil {
+		var remote *Error
+		if !errors.As(err, &remote) {
+			h.abort(t, c, err)
+		}
+	}
+	cancel()
+	wg.Wait()
+	return err
+}
+
+// sendInput streams in to the node running a call, as far as its credit
+// allows, and ends the input at EOF
+func (h *Host) sendInput(ctx context.Context, t Transport, c *call, in io.Reader) {
+	for {
+		buf := make([]byte, ChunkSize)
+		n, err := in.Read(buf)
+		for p := buf[:n]; len(p) > 0; {
+			k, cerr := c.in.acquire(ctx, len(p))
+			if cerr != nil {
+				return
+			}
+			if serr := t.SendData(c.node, Data{Call: c.id, ToHost: true, Data: p[:k]}); serr != nil {
+				h.abort(t, c, serr)
+				return
+			}
+			p = p[k:]
+		}
+		if err == io.EOF {
+			_ = t.SendData(c.node, Data{Call: c.id, ToHost: true, Final: true})
+			return
+		}
+		if err != nil {
+			h.abort(t, c, fmt.Errorf("tasks: reading input: %w", err))
+			return
+		}
+	}
+}
+
+// abort ends a call this node made with err, telling the node running it
+func (h *Host) abort(t Transport, c *call, err error) {
+	c.in.end(err)
+	c.abort.Do(func() {
+		_ = t.SendData(c.node, Data{Call: c.id, ToHost: true, Final: true, Code: "canceled", Error: err.Error()})
+	})
+}
+
+// HandleCall runs the handler a peer called, if it is allowed to, and
+// answers refused calls with an error. It does not wait for the handler.
+func (h *Host) HandleCall(from string, c Call) {
+	h.mu.Lock()
+	t := h.transport
+	if t == nil {
+		h.mu.Unlock()
+		return
+	}
+	handler, ok := h.handlers[c.Handler]
+	var err error
+	switch {
+	case !ok:
+		err = fmt.Errorf("%w: %s", ErrUnknownHandler, c.Handler)
+	case !h.allowed(from, c.Handler):
+		err = fmt.Errorf("%w: %s may not run %s", ErrDenied, from, c.Handler)
+	case len(h.hosted) >= h.opts.MaxConcurrent:
+		err = fmt.Errorf("%w: %d running", ErrBusy, len(h.hosted))
+	}
+	key := from + "/" + c.ID
+	if _, dup := h.hosted[key]; dup {
+		h.mu.Unlock()
+		return
+	}
+	if err != nil {
+		h.mu.Unlock()
+		_ = t.SendData(from, Data{Call: c.ID, Final: true, Code: codeOf(err), Error: err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.opts.Timeout)
+	hc := &call{id: c.ID, node: from, handler: c.Handler, host: true, in: newStream(true), cancel: cancel}
+	h.hosted[key] = hc
+	h.mu.Unlock()
+
+	go h.serve(ctx, t, hc, handler, c.Args)
+}
+
+func (h *Host) allowed(node, handler string) bool {
+	for _, allowed := range h.opts.Allow[handler] {
+		if allowed == "*" || allowed == node {
+			return true
+		}
+	}
+	return false
+}
+
+// serve runs the handler of a call of a peer and tells the peer how it
+// ended, unless the peer canceled it
+func (h *Host) serve(ctx context.Context, t Transport, c *call, handler Handler, args map[string]string) {
+	defer func() {
+		c.cancel()
+		h.mu.Lock()
+		delete(h.hosted, c.node+"/"+c.id)
+		h.mu.Unlock()
+	}()
+
+	err := handler.Run(ctx, args, &reader{ctx: ctx, transport: t, call: c}, &writer{ctx: ctx, transport: t, call: c})
+	if ended := c.in.failure(); ended != nil {
+		if errors.Is(ended, ErrCanceled) {
+			return
+		}
+		err = ended
+	}
+	final := Data{Call: c.id, Final: true}
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s: %w", h.opts.Timeout, err)
+		}
+		final.Code, final.Error = codeOf(err), err.Error()
+	}
+	_ = t.SendData(c.node, final)
+}
+
+// HandleData hands data of a peer to the call it belongs to. It does not
+// block: the credit bounds what the peer sends ahead.
+func (h *Host) HandleData(from string, d Data) {
+	h.mu.Lock()
+	t := h.transport
+	var c *call
+	if d.ToHost {
+		c = h.hosted[from+"/"+d.Call]
+	} else if made, ok := h.calls[d.Call]; ok && made.node == from {
+		c = made
+	}
+	h.mu.Unlock()
+	if c == nil {
+		return
+	}
+
+	var err error
+	if d.Final && d.Error != "" {
+		if c.host {
+			err = fmt.Errorf("%w: %s", ErrCanceled, d.Error)
+		} else {
+			sentinel, ok := codes[d.Code]
+			if !ok {
+				sentinel = ErrFailed
+			}
+			err = &Error{Node: from, Handler: c.handler, Err: sentinel, Message: d.Error}
+		}
+	}
+	if c.in.receive(d, err) {
+		if err != nil && c.host {
+			c.cancel()
+		}
+		return
+	}
+	// The peer overran its credit
+	if c.host {
+		c.in.end(ErrProtocol)
+		c.cancel()
+		return
+	}
+	h.abort(t, c, ErrProtocol)
+}
+
+// reader reads what the peer of a call sends, granting it credit for what
+// was read
+type reader struct {
+	ctx       context.Context
+	transport Transport
+	call      *call
+	consumed  int64
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.call.in.read(r.ctx, p)
+	r.consumed += int64(n)
+	if r.consumed >= Window/4 && err == nil {
+		c := r.call
+		_ = r.transport.SendData(c.node, Data{Call: c.id, ToHost: !c.host, Credit: r.consumed})
+		r.consumed = 0
+	}
+	return n, err
+}
+
+// writer sends the output of a call to its caller as its credit allows
+type writer struct {
+	ctx       context.Context
+	transport Transport
+	call      *call
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		k, err := w.call.in.acquire(w.ctx, min(len(p), ChunkSize))
+		if err != nil {
+			return written, err
+		}
+		// Transports may hold on to the data, which the caller may reuse
+		if err := w.transport.SendData(w.call.node, Data{Call: w.call.id, Data: slices.Clone(p[:k])}); err != nil {
+			return written, err
+		}
+		written += k
+		p = p[k:]
+	}
+	return written, nil
+}
+
+// stream holds what the peer of a call sent that was not read yet, and
+// the credit it granted this node
+type stream struct {
+	// host is set on the streams of the calls this node runs, which go on
+	// once the caller's input ended
+	host bool
+
+	mu       sync.Mutex
+	chunks   [][]byte
+	buffered int
+	// ended is set once the peer sent its last data, closed once the call
+	// is over; err is why it ended early
+	ended, closed bool
+	err           error
+	credit        int64
+	// changed is closed when any of the above changes
+	changed chan struct{}
+}
+
+func newStream(host bool) *stream {
+	return &stream{host: host, credit: Window, changed: make(chan struct{})}
+}
+
+func (s *stream) broadcast() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// receive adds data of the peer, ending the stream with err when it is
+// final. It reports false when the peer sent more than its credit.
+func (s *stream) receive(d Data, err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return true
+	}
+	defer s.broadcast()
+	s.credit += d.Credit
+	if len(d.Data) > 0 {
+		if s.ended || s.buffered+len(d.Data) > Window {
+			return false
+		}
+		s.chunks = append(s.chunks, d.Data)
+		s.buffered += len(d.Data)
+	}
+	if d.Final {
+		s.ended = true
+		if err != nil || !s.host {
+			s.closed, s.err = true, err
+		}
+	}
+	return true
+}
+
+// end closes the stream with err, dropping what was not read
+func (s *stream) end(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.chunks, s.buffered = nil, 0
+	s.ended, s.closed, s.err = true, true, err
+	s.broadcast()
+}
+
+// failure returns why the call ended early, if it did
+func (s *stream) failure() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *stream) read(ctx context.Context, p []byte) (int, error) {
+	for {
+		s.mu.Lock()
+		if len(s.chunks) > 0 {
+			n := copy(p, s.chunks[0])
+			if n == len(s.chunks[0]) {
+				s.chunks = s.chunks[1:]
+			} else {
+				s.chunks[0] = s.chunks[0][n:]
+			}
+			s.buffered -= n
+			s.mu.Unlock()
+			return n, nil
+		}
+		if s.ended {
+			err := s.err
+			s.mu.Unlock()
+			if err == nil {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// acquire waits for credit to send up to want bytes, returning how many
+// may be sent
+func (s *stream) acquire(ctx context.Context, want int) (int, error) {
+	for {
+		s.mu.Lock()
+		if s.closed {
+			err := s.err
+			s.mu.Unlock()
+			if err == nil {
+				err = ErrCanceled
+			}
+			return 0, err
+		}
+		if s.credit > 0 {
+			n := min(int64(want), s.credit)
+			s.credit -= n
+			s.mu.Unlock()
+			return int(n), nil
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// eof is the input of calls without one
+type eof struct{}
+
+func (eof) Read([]byte) (int, error) { return 0, io.EOF }
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
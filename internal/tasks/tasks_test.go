@@ -0,0 +1,197 @@
+package tasks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// network connects hosts by node ID
+type network struct {
+	mu    sync.Mutex
+	hosts map[string]*Host
+}
+
+// link is the transport of one node of a network
+type link struct {
+	net  *network
+	from string
+}
+
+func newNetwork(t *testing.T, opts Options, ids ...string) []*Host {
+	n := &network{hosts: make(map[string]*Host)}
+	var hosts []*Host
+	for _, id := range ids {
+		h := New(opts)
+		require.NoError(t, RegisterBuiltins(h))
+		h.Attach(id, link{net: n, from: id})
+		n.hosts[id] = h
+		hosts = append(hosts, h)
+	}
+	return hosts
+}
+
+func (l link) reach(node string) (*Host, error) {
+	l.net.mu.Lock()
+	defer l.net.mu.Unlock()
+	h, ok := l.net.hosts[node]
+	if !ok {
+		return nil, ErrUnreachable
+	}
+	return h, nil
+}
+
+func (l link) SendCall(node string, c Call) error {
+	h, err := l.reach(node)
+	if err != nil {
+		return err
+	}
+	h.HandleCall(l.from, c)
+	return nil
+}
+
+func (l link) SendData(node string, d Data) error {
+	h, err := l.reach(node)
+	if err != nil {
+		return err
+	}
+	h.HandleData(l.from, d)
+	return nil
+}
+
+func TestInvokeStreamsInputAndOutput(t *testing.T) {
+	hosts := newNetwork(t, Options{Allow: map[string][]string{"compress": {"*"}, "hash-check": {"node-a"}}}, "node-a", "node-b")
+	a := hosts[0]
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// More than a window each way, so the streams wait for credit
+	data := make([]byte, 3*Window)
+	rand.New(rand.NewSource(1)).Read(data)
+	var compressed bytes.Buffer
+	require.NoError(t, a.Invoke(ctx, "node-b", "compress", map[string]string{"level": "1"}, bytes.NewReader(data), &compressed))
+	zr, err := gzip.NewReader(&compressed)
+	require.NoError(t, err)
+	got, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	sum := sha256.Sum256(data)
+	var digest bytes.Buffer
+	require.NoError(t, a.Invoke(ctx, "node-b", "hash-check", map[string]string{"sha256": hex.EncodeToString(sum[:])}, bytes.NewReader(data), &digest))
+	assert.Equal(t, hex.EncodeToString(sum[:]), digest.String())
+
+	// Errors of handlers come back to the caller
+	err = a.Invoke(ctx, "node-b", "hash-check", map[string]string{"sha256": "00"}, bytes.NewReader(data), io.Discard)
+	assert.ErrorIs(t, err, ErrFailed)
+	var remote *Error
+	require.ErrorAs(t, err, &remote)
+	assert.Equal(t, "node-b", remote.Node)
+	assert.Contains(t, remote.Message, "digest mismatch")
+	err = a.Invoke(ctx, "node-b", "compress", map[string]string{"level": "10"}, nil, io.Discard)
+	assert.ErrorIs(t, err, ErrFailed)
+
+	// Calls of this node run directly
+	var local bytes.Buffer
+	require.NoError(t, a.Invoke(ctx, "node-a", "hash-check", nil, bytes.NewReader(nil), &local))
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", local.String())
+}
+
+func TestInvokeRefusals(t *testing.T) {
+	release := make(chan struct{})
+	hosts := newNetwork(t, Options{MaxConcurrent: 1, Allow: map[string][]string{"block": {"node-a"}, "hash-check": {"node-c"}}}, "node-a", "node-b")
+	a, b := hosts[0], hosts[1]
+	require.NoError(t, b.Register("block", HandlerFunc(func(ctx context.Context, args map[string]string, in io.Reader, out io.Writer) error {
+		<-release
+		return nil
+	})))
+	assert.ErrorIs(t, b.Register("block", HashCheck), ErrInvalid)
+	assert.ErrorIs(t, b.Register("No Spaces", HashCheck), ErrInvalid)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	assert.ErrorIs(t, a.Invoke(ctx, "node-b", "missing", nil, nil, io.Discard), ErrUnknownHandler)
+	assert.ErrorIs(t, a.Invoke(ctx, "node-b", "hash-check", nil, nil, io.Discard), ErrDenied)
+	assert.ErrorIs(t, a.Invoke(ctx, "node-z", "hash-check", nil, nil, io.Discard), ErrUnreachable)
+	assert.ErrorIs(t, New(Options{}).Invoke(ctx, "node-b", "hash-check", nil, nil, io.Discard), ErrDetached)
+
+	done := make(chan error, 1)
+	go func() { done <- a.Invoke(ctx, "node-b", "block", nil, nil, io.Discard) }()
+	require.Eventually(t, func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return len(b.hosted) == 1
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.ErrorIs(t, a.Invoke(ctx, "node-b", "block", nil, nil, io.Discard), ErrBusy)
+	close(release)
+	require.NoError(t, <-done)
+}
+
+func TestInvokeCancelAndDisconnect(t *testing.T) {
+	stopped := make(chan error, 1)
+	hosts := newNetwork(t, Options{Allow: map[string][]string{"wait": {"*"}}}, "node-a", "node-b")
+	a, b := hosts[0], hosts[1]
+	require.NoError(t, b.Register("wait", HandlerFunc(func(ctx context.Context, args map[string]string, in io.Reader, out io.Writer) error {
+		if _, err := out.Write([]byte("started")); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return ctx.Err()
+	})))
+
+	// The handler stops when the caller gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	out := &notifyWriter{written: make(chan struct{})}
+	go func() {
+		<-out.written
+		cancel()
+	}()
+	assert.ErrorIs(t, a.Invoke(ctx, "node-b", "wait", nil, nil, out), context.Canceled)
+	assert.ErrorIs(t, <-stopped, context.Canceled)
+
+	// Calls fail when the node running them disconnects, which cancels them
+	// there
+	done := make(chan error, 1)
+	out = &notifyWriter{written: make(chan struct{})}
+	go func() { done <- a.Invoke(context.Background(), "node-b", "wait", nil, nil, out) }()
+	<-out.written
+	a.DropNode("node-b")
+	b.DropNode("node-a")
+	err := <-done
+	assert.ErrorIs(t, err, ErrUnreachable)
+	assert.ErrorIs(t, <-stopped, context.Canceled)
+}
+
+func TestAdvertisedHandlers(t *testing.T) {
+	h := New(Options{})
+	h.Advertise("node-b", []string{"compress", "hash-check"})
+	h.Advertise("node-c", []string{"hash-check"})
+	assert.Equal(t, []string{"node-b", "node-c"}, h.Nodes("hash-check"))
+	assert.Equal(t, []string{"node-b"}, h.Nodes("compress"))
+	h.DropNode("node-b")
+	assert.Equal(t, []string{"node-c"}, h.Nodes("hash-check"))
+	assert.Empty(t, h.Nodes("compress"))
+	assert.Equal(t, map[string][]string{"node-c": {"hash-check"}}, h.Peers())
+}
+
+// notifyWriter closes written on the first write
+type notifyWriter struct {
+	once    sync.Once
+	written chan struct{}
+}
+
+func (w *notifyWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.written) })
+	return len(p), nil
+}
@@ -0,0 +1,400 @@
+// Package telemetry aggregates the numeric readings devices report into
+// time-bucketed rollups per device and metric. A rollup keeps the count,
+// sum, minimum and maximum of its readings, so queries can average,
+// downsample and group them without keeping every reading, and dashboards
+// can chart device telemetry without an external time-series database.
+package telemetry
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBucketSize is the length of a rollup
+	DefaultBucketSize = time.Minute
+	// DefaultRetention is how long rollups are kept
+	DefaultRetention = 7 * 24 * time.Hour
+	// DefaultMaxSeries is how many device and metric pairs are collected
+	// before readings of new ones are dropped
+	DefaultMaxSeries = 10000
+)
+
+// TopicPrefix is the MQTT topic prefix devices publish readings under,
+// followed by their device ID
+const TopicPrefix = "telemetry/"
+
+var (
+	// ErrInvalid is returned for readings without a device or metric, or
+	// whose value is not a finite number
+	ErrInvalid = errors.New("telemetry: invalid reading")
+	// ErrTooManySeries is returned for readings of a new device and metric
+	// pair once MaxSeries are collected
+	ErrTooManySeries = errors.New("telemetry: too many series")
+	// ErrInvalidQuery is returned for queries with an unknown aggregate or
+	// dimension, or an interval that is not a multiple of the bucket size
+	ErrInvalidQuery = errors.New("telemetry: invalid query")
+)
+
+// Reading is a value a device reported for one of its metrics
+type Reading struct {
+	Time   time.Time
+	Device string
+	Metric string
+	Value  float64
+}
+
+// Stats summarize the readings of a bucket
+type Stats struct {
+	Count int64   `json:"count"`
+	Sum   float64 `json:"sum"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+// Avg returns the mean of the readings
+func (s Stats) Avg() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / float64(s.Count)
+}
+
+func (s *Stats) add(o Stats) {
+	if o.Count == 0 {
+		return
+	}
+	if s.Count == 0 {
+		*s = o
+		return
+	}
+	s.Count += o.Count
+	s.Sum += o.Sum
+	s.Min = min(s.Min, o.Min)
+	s.Max = max(s.Max, o.Max)
+}
+
+// Aggregate is how the readings of a bucket are summed up into one value
+type Aggregate string
+
+const (
+	Avg   Aggregate = "avg"
+	Min   Aggregate = "min"
+	Max   Aggregate = "max"
+	Sum   Aggregate = "sum"
+	Count Aggregate = "count"
+)
+
+// ParseAggregate returns the aggregate named s
+func ParseAggregate(s string) (Aggregate, error) {
+	switch a := Aggregate(s); a {
+	case Avg, Min, Max, Sum, Count:
+		return a, nil
+	}
+	return "", fmt.Errorf("%w: aggregate must be avg, min, max, sum or count", ErrInvalidQuery)
+}
+
+// Value returns the aggregate of s
+func (a Aggregate) Value(s Stats) float64 {
+	switch a {
+	case Min:
+		return s.Min
+	case Max:
+		return s.Max
+	case Sum:
+		return s.Sum
+	case Count:
+		return float64(s.Count)
+	}
+	return s.Avg()
+}
+
+// Dimension is what readings are grouped by
+type Dimension string
+
+const (
+	ByDevice Dimension = "device"
+	ByMetric Dimension = "metric"
+)
+
+// ParseDimension returns the dimension named s
+func ParseDimension(s string) (Dimension, error) {
+	switch d := Dimension(s); d {
+	case ByDevice, ByMetric:
+		return d, nil
+	}
+	return "", fmt.Errorf("%w: dimension must be device or metric", ErrInvalidQuery)
+}
+
+// Query selects readings and how to aggregate them
+type Query struct {
+	// Device and Metric restrict the readings to one device or metric
+	Device string
+	Metric string
+	// From and To select the rollups whose bucket overlaps them; zero
+	// leaves them open
+	From, To time.Time
+	// Interval downsamples the rollups into points of that length, a
+	// multiple of the bucket size; zero keeps a point per bucket
+	Interval time.Duration
+	// GroupBy splits the readings into a series per device, per metric or
+	// per both; without it every reading selected is merged into one
+	// series
+	GroupBy []Dimension
+}
+
+// Point summarizes the readings of a series during an interval
+type Point struct {
+	Start time.Time `json:"start"`
+	Stats
+}
+
+// Series are the points of a device, a metric or both, in time order
+type Series struct {
+	// Device and Metric are those the series is grouped by or the query
+	// restricted to; empty otherwise
+	Device string  `json:"device,omitempty"`
+	Metric string  `json:"metric,omitempty"`
+	Points []Point `json:"points"`
+}
+
+// Options configures a collector
+type Options struct {
+	// Path persists the rollups; empty keeps them in memory
+	Path string
+	// BucketSize is the length of a rollup; zero uses DefaultBucketSize
+	BucketSize time.Duration
+	// Retention is how long rollups are kept; zero uses DefaultRetention
+	Retention time.Duration
+	// MaxSeries bounds the device and metric pairs collected; zero uses
+	// DefaultMaxSeries
+	MaxSeries int
+}
+
+// seriesKey names the readings of one metric of one device
+type seriesKey struct {
+	Device string
+	Metric string
+}
+
+// rollup is how rollups are persisted
+type rollup struct {
+	Start  time.Time `json:"start"`
+	Device string    `json:"device"`
+	Metric string    `json:"metric"`
+	Stats
+}
+
+// Collector aggregates readings into rollups
+type Collector struct {
+	mu      sync.Mutex
+	opts    Options
+	buckets map[int64]map[seriesKey]*Stats
+	// series holds the start of the newest bucket of every series
+	series map[seriesKey]int64
+	dirty  bool
+}
+
+// NewCollector creates a collector
+func NewCollector(opts Options) *Collector {
+	if opts.BucketSize <= 0 {
+		opts.BucketSize = DefaultBucketSize
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = DefaultRetention
+	}
+	if opts.MaxSeries <= 0 {
+		opts.MaxSeries = DefaultMaxSeries
+	}
+	return &Collector{opts: opts, buckets: make(map[int64]map[seriesKey]*Stats), series: make(map[seriesKey]int64)}
+}
+
+// BucketSize returns the length of a rollup
+func (c *Collector) BucketSize() time.Duration { return c.opts.BucketSize }
+
+// Record adds a reading to the rollup of its device and metric. Readings
+// without a time are recorded now.
+func (c *Collector) Record(r Reading) error {
+	if r.Device == "" || r.Metric == "" || math.IsNaN(r.Value) || math.IsInf(r.Value, 0) {
+		return ErrInvalid
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	start := r.Time.Truncate(c.opts.BucketSize).Unix()
+	key := seriesKey{Device: r.Device, Metric: r.Metric}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	newest, ok := c.series[key]
+	if !ok && len(c.series) >= c.opts.MaxSeries {
+		return ErrTooManySeries
+	}
+	c.series[key] = max(newest, start)
+	b, ok := c.buckets[start]
+	if !ok {
+		b = make(map[seriesKey]*Stats)
+		c.buckets[start] = b
+	}
+	stats, ok := b[key]
+	if !ok {
+		stats = &Stats{}
+		b[key] = stats
+	}
+	stats.add(Stats{Count: 1, Sum: r.Value, Min: r.Value, Max: r.Value})
+	c.dirty = true
+	return nil
+}
+
+// Query returns the series selected by q, ordered by device then metric
+func (c *Collector) Query(q Query) ([]Series, error) {
+	if q.Interval < 0 || q.Interval%c.opts.BucketSize != 0 {
+		return nil, fmt.Errorf("%w: interval must be a multiple of %s", ErrInvalidQuery, c.opts.BucketSize)
+	}
+	interval := max(q.Interval, c.opts.BucketSize)
+	var byDevice, byMetric bool
+	for _, d := range q.GroupBy {
+		if _, err := ParseDimension(string(d)); err != nil {
+			return nil, err
+		}
+		byDevice = byDevice || d == ByDevice
+		byMetric = byMetric || d == ByMetric
+	}
+
+	c.mu.Lock()
+	groups := make(map[seriesKey]map[int64]*Stats)
+	for start, b := range c.buckets {
+		t := time.Unix(start, 0).UTC()
+		if (!q.From.IsZero() && !t.Add(c.opts.BucketSize).After(q.From)) || (!q.To.IsZero() && !t.Before(q.To)) {
+			continue
+		}
+		point := t.Truncate(interval).Unix()
+		for key, stats := range b {
+			if (q.Device != "" && key.Device != q.Device) || (q.Metric != "" && key.Metric != q.Metric) {
+				continue
+			}
+			group := seriesKey{Device: q.Device, Metric: q.Metric}
+			if byDevice {
+				group.Device = key.Device
+			}
+			if byMetric {
+				group.Metric = key.Metric
+			}
+			points, ok := groups[group]
+			if !ok {
+				points = make(map[int64]*Stats)
+				groups[group] = points
+			}
+			sum, ok := points[point]
+			if !ok {
+				sum = &Stats{}
+				points[point] = sum
+			}
+			sum.add(*stats)
+		}
+	}
+	c.mu.Unlock()
+
+	series := make([]Series, 0, len(groups))
+	for group, points := range groups {
+		s := Series{Device: group.Device, Metric: group.Metric, Points: make([]Point, 0, len(points))}
+		for _, start := range slices.Sorted(maps.Keys(points)) {
+			s.Points = append(s.Points, Point{Start: time.Unix(start, 0).UTC(), Stats: *points[start]})
+		}
+		series = append(series, s)
+	}
+	slices.SortFunc(series, func(a, b Series) int {
+		return cmp.Or(cmp.Compare(a.Device, b.Device), cmp.Compare(a.Metric, b.Metric))
+	})
+	return series, nil
+}
+
+// Flush drops the rollups older than the retention and persists the rest
+// when readings were recorded since the last flush
+func (c *Collector) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cutoff := time.Now().Add(-c.opts.Retention).Unix()
+	for start := range c.buckets {
+		if start < cutoff {
+			delete(c.buckets, start)
+			c.dirty = true
+		}
+	}
+	for key, newest := range c.series {
+		if newest < cutoff {
+			delete(c.series, key)
+		}
+	}
+	if !c.dirty || c.opts.Path == "" {
+		return nil
+	}
+	var rollups []rollup
+	for start, b := range c.buckets {
+		for key, stats := range b {
+			rollups = append(rollups, rollup{Start: time.Unix(start, 0).UTC(), Device: key.Device, Metric: key.Metric, Stats: *stats})
+		}
+	}
+	data, err := json.Marshal(rollups)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.opts.Path), 0700); err != nil {
+		return err
+	}
+	tmp := c.opts.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.opts.Path); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// Load reads the persisted rollups, adding them to those recorded so far
+func (c *Collector) Load() error {
+	if c.opts.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var rollups []rollup
+	if err := json.Unmarshal(data, &rollups); err != nil {
+		return fmt.Errorf("telemetry: corrupt rollups %s: %w", c.opts.Path, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range rollups {
+		start := r.Start.Truncate(c.opts.BucketSize).Unix()
+		key := seriesKey{Device: r.Device, Metric: r.Metric}
+		b, ok := c.buckets[start]
+		if !ok {
+			b = make(map[seriesKey]*Stats)
+			c.buckets[start] = b
+		}
+		stats, ok := b[key]
+		if !ok {
+			stats = &Stats{}
+			b[key] = stats
+		}
+		stats.add(r.Stats)
+		c.series[key] = max(c.series[key], start)
+	}
+	return nil
+}
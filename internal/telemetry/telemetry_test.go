@@ -0,0 +1,88 @@
+package telemetry
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectorAggregatesReadings(t *testing.T) {
+	c := NewCollector(Options{})
+	hour := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+	for i, v := range []float64{20, 22, 21, 25} {
+		require.NoError(t, c.Record(Reading{Time: hour.Add(time.Duration(i) * 2 * time.Minute), Device: "sensor-1", Metric: "temperature", Value: v}))
+	}
+	require.NoError(t, c.Record(Reading{Time: hour, Device: "sensor-2", Metric: "temperature", Value: 30}))
+	require.NoError(t, c.Record(Reading{Time: hour, Device: "sensor-1", Metric: "humidity", Value: 40}))
+
+	// A point per minute bucket
+	series, err := c.Query(Query{Device: "sensor-1", Metric: "temperature"})
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+	assert.Equal(t, "sensor-1", series[0].Device)
+	require.Len(t, series[0].Points, 4)
+	assert.Equal(t, Point{Start: hour, Stats: Stats{Count: 1, Sum: 20, Min: 20, Max: 20}}, series[0].Points[0])
+
+	// Downsampled to five minutes
+	series, err = c.Query(Query{Device: "sensor-1", Metric: "temperature", Interval: 5 * time.Minute})
+	require.NoError(t, err)
+	require.Len(t, series[0].Points, 2)
+	assert.Equal(t, Stats{Count: 3, Sum: 63, Min: 20, Max: 22}, series[0].Points[0].Stats)
+	assert.Equal(t, 21.0, Avg.Value(series[0].Points[0].Stats))
+	assert.Equal(t, hour.Add(5*time.Minute), series[0].Points[1].Start)
+
+	// Grouped by device, over an hour
+	series, err = c.Query(Query{Metric: "temperature", Interval: time.Hour, GroupBy: []Dimension{ByDevice}})
+	require.NoError(t, err)
+	require.Len(t, series, 2)
+	assert.Equal(t, Series{Device: "sensor-1", Metric: "temperature", Points: []Point{{Start: hour, Stats: Stats{Count: 4, Sum: 88, Min: 20, Max: 25}}}}, series[0])
+	assert.Equal(t, "sensor-2", series[1].Device)
+
+	// Ungrouped, every reading of the range is merged
+	series, err = c.Query(Query{From: hour, To: hour.Add(time.Minute), Interval: time.Hour})
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+	assert.Equal(t, Series{Points: []Point{{Start: hour, Stats: Stats{Count: 3, Sum: 90, Min: 20, Max: 40}}}}, series[0])
+
+	series, err = c.Query(Query{Device: "sensor-1", GroupBy: []Dimension{ByMetric}})
+	require.NoError(t, err)
+	require.Len(t, series, 2)
+	assert.Equal(t, "humidity", series[0].Metric)
+
+	_, err = c.Query(Query{Interval: 90 * time.Second})
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+	_, err = c.Query(Query{GroupBy: []Dimension{"tenant"}})
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+	_, err = ParseAggregate("median")
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+}
+
+func TestCollectorRejectsReadings(t *testing.T) {
+	c := NewCollector(Options{MaxSeries: 1})
+	assert.ErrorIs(t, c.Record(Reading{Device: "sensor-1", Metric: "temperature", Value: math.NaN()}), ErrInvalid)
+	assert.ErrorIs(t, c.Record(Reading{Device: "sensor-1", Value: 1}), ErrInvalid)
+	require.NoError(t, c.Record(Reading{Device: "sensor-1", Metric: "temperature", Value: 1}))
+	require.NoError(t, c.Record(Reading{Device: "sensor-1", Metric: "temperature", Value: 2}))
+	assert.ErrorIs(t, c.Record(Reading{Device: "sensor-2", Metric: "temperature", Value: 1}), ErrTooManySeries)
+}
+
+func TestCollectorPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telemetry.json")
+	c := NewCollector(Options{Path: path, Retention: 24 * time.Hour, MaxSeries: 1})
+	require.NoError(t, c.Record(Reading{Device: "sensor-1", Metric: "temperature", Value: 21}))
+	require.NoError(t, c.Flush())
+	require.NoError(t, c.Record(Reading{Time: time.Now().Add(-48 * time.Hour), Device: "sensor-1", Metric: "temperature", Value: 5}))
+	require.NoError(t, c.Flush())
+
+	reloaded := NewCollector(Options{Path: path})
+	require.NoError(t, reloaded.Load())
+	series, err := reloaded.Query(Query{})
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+	require.Len(t, series[0].Points, 1, "rollups past the retention are dropped")
+	assert.Equal(t, 21.0, series[0].Points[0].Max)
+}
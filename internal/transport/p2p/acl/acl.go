@@ -0,0 +1,413 @@
+// Package acl decides which peers may connect to a node. Rules allow or
+// deny peers by node ID, by the network their address is in, or by whether
+// they proved an identity key. The rules are checked during the handshake,
+// so a rejected peer never reaches the file server, and every rejection is
+// recorded in the audit log.
+package acl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/audit"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+)
+
+var (
+	// ErrNotFound is returned for rules that do not exist
+	ErrNotFound = errors.New("acl: not found")
+	// ErrInvalid is returned for malformed rules
+	ErrInvalid = errors.New("acl: invalid rule")
+	// ErrReadOnly is returned when removing a rule of the configuration
+	ErrReadOnly = errors.New("acl: rule comes from the configuration")
+	// ErrDenied is returned for peers the rules do not let in
+	ErrDenied = errors.New("acl: peer denied")
+)
+
+// Action is what a rule does with the peers it matches
+type Action string
+
+const (
+	Allow Action = "allow"
+	Deny  Action = "deny"
+)
+
+// Identity values of rules
+const (
+	// IdentityVerified matches peers that proved an identity key
+	IdentityVerified = "verified"
+	// IdentityNone matches peers without an identity key
+	IdentityNone = "none"
+)
+
+// Rule allows or denies the peers matching every criterion it sets
+type Rule struct {
+	ID     string `json:"id"`
+	Action Action `json:"action"`
+	// NodeID matches the node ID the peer authenticated as
+	NodeID string `json:"node_id,omitempty"`
+	// CIDR matches the peer's address, such as 10.0.0.0/8 or a single IP
+	CIDR string `json:"cidr,omitempty"`
+	// Identity matches peers that proved an identity key, "verified", or
+	// that did not, "none"
+	Identity string `json:"identity,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+	// Static rules come from the configuration and cannot be removed at
+	// runtime
+	Static    bool      `json:"static,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	prefix netip.Prefix
+}
+
+// Peer is what the rules know about a connecting peer
+type Peer struct {
+	Addr   string
+	NodeID string
+	// Verified reports whether the peer proved an identity key
+	Verified bool
+}
+
+// Options configures an ACL
+type Options struct {
+	// Rules are the rules of the configuration
+	Rules []Rule
+	// Path persists the rules added at runtime; empty keeps them in memory
+	Path string
+	// Audit receives the rejected connections and rule changes; nil uses
+	// the global audit logger
+	Audit *audit.AuditLogger
+	// Now returns the current time; nil uses time.Now
+	Now func() time.Time
+}
+
+// ACL holds the rules of a node. Deny rules win over allow rules; once
+// there is an allow rule, only peers matching one get in.
+type ACL struct {
+	opts Options
+
+	mu    sync.RWMutex
+	rules []Rule
+}
+
+// New returns an ACL with the configured rules and the runtime rules
+// persisted at opts.Path
+func New(opts Options) (*ACL, error) {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	a := &ACL{opts: opts}
+	for i, rule := range opts.Rules {
+		rule.ID = "config-" + strconv.Itoa(i+1)
+		rule.Static = true
+		if err := rule.compile(); err != nil {
+			return nil, err
+		}
+		a.rules = append(a.rules, rule)
+	}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Rules returns the rules, those of the configuration first
+func (a *ACL) Rules() []Rule {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return slices.Clone(a.rules)
+}
+
+// AddRule adds a rule at runtime. It only applies to new connections; see
+// Check for re-checking connected peers.
+func (a *ACL) AddRule(ctx context.Context, rule Rule, actor string) (Rule, error) {
+	if err := rule.compile(); err != nil {
+		return Rule{}, err
+	}
+	rule.ID = crypto.GenerateID()[:16]
+	rule.Static = false
+	rule.CreatedAt = a.opts.Now().UTC()
+
+	a.mu.Lock()
+	a.rules = append(a.rules, rule)
+	err := a.saveLocked()
+	if err != nil {
+		a.rules = a.rules[:len(a.rules)-1]
+	}
+	a.mu.Unlock()
+	if err != nil {
+		return Rule{}, err
+	}
+
+	a.auditChange(ctx, "add_rule", rule, actor)
+	return rule, nil
+}
+
+// RemoveRule removes a rule added at runtime
+func (a *ACL) RemoveRule(ctx context.Context, id, actor string) error {
+	a.mu.Lock()
+	i := slices.IndexFunc(a.rules, func(r Rule) bool { return r.ID == id })
+	if i < 0 {
+		a.mu.Unlock()
+		return fmt.Errorf("%w: rule %s", ErrNotFound, id)
+	}
+	rule := a.rules[i]
+	if rule.Static {
+		a.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrReadOnly, id)
+	}
+	a.rules = slices.Delete(a.rules, i, i+1)
+	err := a.saveLocked()
+	if err != nil {
+		a.rules = slices.Insert(a.rules, i, rule)
+	}
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	a.auditChange(ctx, "remove_rule", rule, actor)
+	return nil
+}
+
+// Check returns an error wrapping ErrDenied when the rules keep p out
+func (a *ACL) Check(p Peer) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	addr := parseAddr(p.Addr)
+	hasAllow, allowed := false, false
+	for _, rule := range a.rules {
+		if rule.Action == Allow {
+			hasAllow = true
+		}
+		if !rule.matches(p, addr) {
+			continue
+		}
+		if rule.Action == Deny {
+			return fmt.Errorf("%w by rule %s", ErrDenied, rule.ID)
+		}
+		allowed = true
+	}
+	if hasAllow && !allowed {
+		return fmt.Errorf("%w: no allow rule matches", ErrDenied)
+	}
+	return nil
+}
+
+// checkAddr rejects peers by address alone, before the handshake: only
+// deny rules setting nothing but a CIDR can tell
+func (a *ACL) checkAddr(remote string) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	addr := parseAddr(remote)
+	for _, rule := range a.rules {
+		if rule.Action == Deny && rule.NodeID == "" && rule.Identity == "" && rule.prefix.IsValid() && addr.IsValid() && rule.prefix.Contains(addr) {
+			return fmt.Errorf("%w by rule %s", ErrDenied, rule.ID)
+		}
+	}
+	return nil
+}
+
+// Handshake wraps the handshake of a transport with the rules. Peers denied
+// by address are dropped before the handshake; the others once it told
+// who they are.
+func (a *ACL) Handshake(next netp2p.HandshakeFunc) netp2p.HandshakeFunc {
+	return func(p netp2p.Peer) error {
+		remote := p.RemoteAddr().String()
+		if err := a.checkAddr(remote); err != nil {
+			a.auditDenied(Peer{Addr: remote}, err)
+			return err
+		}
+		if err := next(p); err != nil {
+			return err
+		}
+		peer := Peer{Addr: remote}
+		if tcp, ok := p.(*netp2p.TCPPeer); ok {
+			peer.NodeID = tcp.NodeID()
+			peer.Verified = tcp.PublicKey() != nil
+		}
+		if err := a.Check(peer); err != nil {
+			a.auditDenied(peer, err)
+			return err
+		}
+		return nil
+	}
+}
+
+// compile validates the rule and parses its CIDR
+func (r *Rule) compile() error {
+	switch r.Action {
+	case Allow, Deny:
+	default:
+		return fmt.Errorf("%w: action must be allow or deny, not %q", ErrInvalid, r.Action)
+	}
+	switch r.Identity {
+	case "", IdentityVerified, IdentityNone:
+	default:
+		return fmt.Errorf("%w: identity must be %s or %s, not %q", ErrInvalid, IdentityVerified, IdentityNone, r.Identity)
+	}
+	if r.CIDR != "" {
+		prefix, err := netip.ParsePrefix(r.CIDR)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(r.CIDR)
+			if addrErr != nil {
+				return fmt.Errorf("%w: invalid CIDR %q", ErrInvalid, r.CIDR)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		r.prefix = prefix.Masked()
+	}
+	if r.NodeID == "" && r.CIDR == "" && r.Identity == "" {
+		return fmt.Errorf("%w: set a node ID, CIDR or identity", ErrInvalid)
+	}
+	return nil
+}
+
+func (r *Rule) matches(p Peer, addr netip.Addr) bool {
+	if r.NodeID != "" && r.NodeID != p.NodeID {
+		return false
+	}
+	if r.prefix.IsValid() && (!addr.IsValid() || !r.prefix.Contains(addr)) {
+		return false
+	}
+	switch r.Identity {
+	case IdentityVerified:
+		return p.Verified
+	case IdentityNone:
+		return !p.Verified
+	}
+	return true
+}
+
+// parseAddr returns the IP of a host:port or bare address
+func parseAddr(s string) netip.Addr {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+func (a *ACL) load() error {
+	if a.opts.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(a.opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("acl: corrupt rule store %s: %w", a.opts.Path, err)
+	}
+	for _, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return fmt.Errorf("acl: rule %s in %s: %w", rule.ID, a.opts.Path, err)
+		}
+		rule.Static = false
+		a.rules = append(a.rules, rule)
+	}
+	return nil
+}
+
+func (a *ACL) saveLocked() error {
+	if a.opts.Path == "" {
+		return nil
+	}
+	rules := []Rule{}
+	for _, rule := range a.rules {
+		if !rule.Static {
+			rules = append(rules, rule)
+		}
+	}
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(a.opts.Path), 0700); err != nil {
+		return err
+	}
+	tmp := a.opts.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.opts.Path)
+}
+
+func (a *ACL) auditLogger() *audit.AuditLogger {
+	if a.opts.Audit != nil {
+		return a.opts.Audit
+	}
+	return audit.GlobalAuditLogger
+}
+
+// auditDenied records a rejected connection
+func (a *ACL) auditDenied(p Peer, reason error) {
+	logger := a.auditLogger()
+	if logger == nil {
+		slog.Warn("peer connection denied", "addr", p.Addr, "node", p.NodeID, "reason", reason)
+		return
+	}
+	host, _, err := net.SplitHostPort(p.Addr)
+	if err != nil {
+		host = p.Addr
+	}
+	event := &audit.AuditEvent{
+		Type:      audit.AuditEventTypeSecurity,
+		Level:     audit.AuditLevelWarning,
+		IPAddress: host,
+		Resource:  p.NodeID,
+		Action:    "connect",
+		Result:    "denied",
+		Message:   fmt.Sprintf("Peer connection from %s denied: %v", p.Addr, reason),
+		Details:   map[string]interface{}{"addr": p.Addr, "node_id": p.NodeID, "verified": p.Verified},
+		Source:    "peer-acl",
+		Category:  "network",
+		Tags:      []string{"peer-acl", "denied"},
+	}
+	if err := logger.LogEvent(context.Background(), event); err != nil {
+		slog.Warn("acl: failed to write audit event", "error", err)
+	}
+}
+
+// auditChange records a rule change
+func (a *ACL) auditChange(ctx context.Context, action string, rule Rule, actor string) {
+	logger := a.auditLogger()
+	if logger == nil {
+		slog.Info("peer ACL changed", "action", action, "rule", rule.ID, "actor", actor)
+		return
+	}
+	details := map[string]interface{}{"rule": rule.ID, "rule_action": string(rule.Action)}
+	if rule.NodeID != "" {
+		details["node_id"] = rule.NodeID
+	}
+	if rule.CIDR != "" {
+		details["cidr"] = rule.CIDR
+	}
+	if rule.Identity != "" {
+		details["identity"] = rule.Identity
+	}
+	if err := logger.LogAdminEvent(ctx, actor, action, "success", details); err != nil {
+		slog.Warn("acl: failed to write audit event", "error", err)
+	}
+}
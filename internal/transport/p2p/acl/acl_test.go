@@ -0,0 +1,128 @@
+package acl
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skpow1234/Peervault/internal/audit"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+)
+
+func TestCheck(t *testing.T) {
+	acl, err := New(Options{Rules: []Rule{
+		{Action: Deny, CIDR: "10.9.0.0/16"},
+		{Action: Deny, NodeID: "evil"},
+	}})
+	require.NoError(t, err)
+
+	// Without allow rules everything not denied gets in
+	assert.NoError(t, acl.Check(Peer{Addr: "10.1.2.3:3000", NodeID: "good"}))
+	assert.ErrorIs(t, acl.Check(Peer{Addr: "10.9.2.3:3000", NodeID: "good"}), ErrDenied)
+	assert.ErrorIs(t, acl.Check(Peer{Addr: "10.1.2.3:3000", NodeID: "evil"}), ErrDenied)
+
+	// An allow rule turns the ACL into an allowlist; deny rules still win
+	ctx := context.Background()
+	rule, err := acl.AddRule(ctx, Rule{Action: Allow, CIDR: "10.0.0.0/8", Identity: IdentityVerified}, "admin")
+	require.NoError(t, err)
+	assert.NoError(t, acl.Check(Peer{Addr: "10.1.2.3:3000", NodeID: "good", Verified: true}))
+	assert.ErrorIs(t, acl.Check(Peer{Addr: "10.1.2.3:3000", NodeID: "good"}), ErrDenied)
+	assert.ErrorIs(t, acl.Check(Peer{Addr: "192.168.1.1:3000", NodeID: "good", Verified: true}), ErrDenied)
+	assert.ErrorIs(t, acl.Check(Peer{Addr: "10.9.2.3:3000", NodeID: "good", Verified: true}), ErrDenied)
+
+	// Single addresses and IPv4-mapped IPv6 addresses match too
+	_, err = acl.AddRule(ctx, Rule{Action: Allow, CIDR: "192.168.1.1"}, "admin")
+	require.NoError(t, err)
+	assert.NoError(t, acl.Check(Peer{Addr: "[::ffff:192.168.1.1]:3000"}))
+
+	require.NoError(t, acl.RemoveRule(ctx, rule.ID, "admin"))
+	assert.ErrorIs(t, acl.RemoveRule(ctx, rule.ID, "admin"), ErrNotFound)
+	assert.ErrorIs(t, acl.RemoveRule(ctx, "config-1", "admin"), ErrReadOnly)
+}
+
+func TestInvalidRules(t *testing.T) {
+	for _, rule := range []Rule{
+		{Action: "maybe", NodeID: "a"},
+		{Action: Allow},
+		{Action: Allow, CIDR: "not-a-network"},
+		{Action: Deny, Identity: "signed"},
+	} {
+		_, err := New(Options{Rules: []Rule{rule}})
+		assert.ErrorIs(t, err, ErrInvalid, "%+v", rule)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.json")
+	static := []Rule{{Action: Deny, CIDR: "10.9.0.0/16"}}
+	acl, err := New(Options{Rules: static, Path: path})
+	require.NoError(t, err)
+	rule, err := acl.AddRule(context.Background(), Rule{Action: Deny, NodeID: "evil", Comment: "compromised"}, "admin")
+	require.NoError(t, err)
+
+	reloaded, err := New(Options{Rules: static, Path: path})
+	require.NoError(t, err)
+	rules := reloaded.Rules()
+	require.Len(t, rules, 2)
+	assert.True(t, rules[0].Static)
+	assert.Equal(t, rule.ID, rules[1].ID)
+	assert.Equal(t, "compromised", rules[1].Comment)
+	assert.ErrorIs(t, reloaded.Check(Peer{NodeID: "evil"}), ErrDenied)
+}
+
+// handshake connects two transports' handshakes through the ACL of the
+// accepting side, returning the error it saw
+func handshake(t *testing.T, acl *ACL, identity *crypto.Identity) error {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = netp2p.IdentityHandshakeFunc(identity)(netp2p.NewTCPPeer(conn, true))
+	}()
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	server, err := crypto.NewIdentity()
+	require.NoError(t, err)
+	return acl.Handshake(netp2p.IdentityHandshakeFunc(server))(netp2p.NewTCPPeer(conn, false))
+}
+
+func TestHandshake(t *testing.T) {
+	auditLog, err := audit.NewAuditLogger(filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = auditLog.Close() })
+
+	good, err := crypto.NewIdentity()
+	require.NoError(t, err)
+	bad, err := crypto.NewIdentity()
+	require.NoError(t, err)
+
+	acl, err := New(Options{Rules: []Rule{{Action: Deny, NodeID: bad.ID()}}, Audit: auditLog})
+	require.NoError(t, err)
+	assert.NoError(t, handshake(t, acl, good))
+	assert.ErrorIs(t, handshake(t, acl, bad), ErrDenied)
+
+	// Denied networks are dropped before the handshake
+	_, err = acl.AddRule(context.Background(), Rule{Action: Deny, CIDR: "127.0.0.0/8"}, "admin")
+	require.NoError(t, err)
+	assert.ErrorIs(t, handshake(t, acl, good), ErrDenied)
+
+	denied := auditLog.GetEvents(&audit.AuditFilter{Type: audit.AuditEventTypeSecurity, Result: "denied"})
+	require.Len(t, denied, 2)
+	assert.Equal(t, bad.ID(), denied[0].Resource)
+	assert.Equal(t, "127.0.0.1", denied[1].IPAddress)
+	assert.Len(t, auditLog.GetEvents(&audit.AuditFilter{Type: audit.AuditEventTypeAdmin, Action: "add_rule"}), 1)
+}
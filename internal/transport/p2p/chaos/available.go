@@ -0,0 +1,9 @@
+package chaos
+
+import "testing"
+
+// Available reports whether this binary may inject faults: test binaries
+// and binaries built with the chaos build tag
+func Available() bool {
+	return buildTag || testing.Testing()
+}
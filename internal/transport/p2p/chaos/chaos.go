@@ -0,0 +1,347 @@
+// Package chaos injects faults into the P2P layer for resilience testing.
+// An Injector hooks into a TCP transport and can drop, delay, duplicate or
+// corrupt the messages it receives, kill peer connections and cut the node
+// off from parts of the cluster. Experiments script these faults over time.
+//
+// Chaos only runs in test binaries and in binaries built with the chaos
+// build tag, so a configuration mistake cannot break a production cluster.
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+)
+
+// ErrUnavailable is returned by New in binaries that cannot run chaos
+var ErrUnavailable = errors.New("chaos: not available in this build; build with -tags chaos")
+
+// errPartitioned refuses connections across a partition
+var errPartitioned = errors.New("chaos: peer is on the other side of a partition")
+
+// Faults are the probabilities, from 0 to 1, of tampering with each
+// message received from a peer. Several faults can hit the same message.
+type Faults struct {
+	Drop      float64 `yaml:"drop" json:"drop"`
+	Delay     float64 `yaml:"delay" json:"delay"`
+	Duplicate float64 `yaml:"duplicate" json:"duplicate"`
+	Corrupt   float64 `yaml:"corrupt" json:"corrupt"`
+	// MinDelay and MaxDelay bound the delay of delayed messages
+	MinDelay time.Duration `yaml:"min_delay" json:"min_delay"`
+	MaxDelay time.Duration `yaml:"max_delay" json:"max_delay"`
+}
+
+// Validate checks that the probabilities and delays make sense
+func (f Faults) Validate() error {
+	for name, p := range map[string]float64{"drop": f.Drop, "delay": f.Delay, "duplicate": f.Duplicate, "corrupt": f.Corrupt} {
+		if p < 0 || p > 1 {
+			return fmt.Errorf("chaos: %s probability %v is not between 0 and 1", name, p)
+		}
+	}
+	if f.MinDelay < 0 || f.MaxDelay < f.MinDelay {
+		return fmt.Errorf("chaos: invalid delay range %s-%s", f.MinDelay, f.MaxDelay)
+	}
+	return nil
+}
+
+// Stats counts the faults injected so far
+type Stats struct {
+	Messages   int64 `json:"messages"`
+	Dropped    int64 `json:"dropped"`
+	Delayed    int64 `json:"delayed"`
+	Duplicated int64 `json:"duplicated"`
+	Corrupted  int64 `json:"corrupted"`
+	Killed     int64 `json:"killed"`
+	Refused    int64 `json:"refused"`
+}
+
+// Options configure an Injector
+type Options struct {
+	// Self names this node in partitions: its node ID and listen address
+	Self []string
+	// Faults apply from the start
+	Faults Faults
+	// Seed makes fault decisions reproducible; 0 picks a random seed
+	Seed   uint64
+	Logger *slog.Logger
+}
+
+// Injector injects faults into the transport it is hooked into. It
+// implements netp2p.ConnHook.
+type Injector struct {
+	self   map[string]bool
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	rng     *rand.Rand
+	faults  Faults
+	blocked map[string]bool
+	peers   map[*netp2p.TCPPeer]struct{}
+	stats   Stats
+}
+
+var _ netp2p.ConnHook = (*Injector)(nil)
+
+// New creates an injector. It fails with ErrUnavailable outside of test
+// binaries and chaos builds.
+func New(opts Options) (*Injector, error) {
+	if !Available() {
+		return nil, ErrUnavailable
+	}
+	if err := opts.Faults.Validate(); err != nil {
+		return nil, err
+	}
+	seed := opts.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	i := &Injector{
+		self:    make(map[string]bool),
+		logger:  logger.With("component", "chaos"),
+		rng:     rand.New(rand.NewPCG(seed, seed)),
+		faults:  opts.Faults,
+		blocked: make(map[string]bool),
+		peers:   make(map[*netp2p.TCPPeer]struct{}),
+	}
+	for _, name := range opts.Self {
+		i.self[name] = true
+	}
+	i.logger.Warn("Chaos injection enabled", "seed", seed)
+	return i, nil
+}
+
+// SetFaults replaces the message faults
+func (i *Injector) SetFaults(f Faults) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.faults = f
+	return nil
+}
+
+// Faults returns the current message faults
+func (i *Injector) Faults() Faults {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.faults
+}
+
+// Stats returns the faults injected so far
+func (i *Injector) Stats() Stats {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.stats
+}
+
+// Block cuts this node off from the given peers, named by node ID, address
+// or host: their connections are killed, new ones refused and messages
+// still in flight dropped
+func (i *Injector) Block(peers ...string) {
+	i.mu.Lock()
+	for _, name := range peers {
+		i.blocked[name] = true
+	}
+	victims := i.matchingLocked(i.isBlockedLocked)
+	i.mu.Unlock()
+
+	for _, p := range victims {
+		i.kill(p, "partitioned")
+	}
+}
+
+// Partition splits the cluster into groups that cannot reach each other.
+// Every node runs its own injector; this one blocks the members of the
+// groups it is not in, and nobody when it is in none.
+func (i *Injector) Partition(groups ...[]string) {
+	mine := -1
+	for n, group := range groups {
+		if i.inGroup(group) {
+			mine = n
+			break
+		}
+	}
+	if mine < 0 {
+		return
+	}
+	var others []string
+	for n, group := range groups {
+		if n != mine {
+			others = append(others, group...)
+		}
+	}
+	i.Block(others...)
+}
+
+func (i *Injector) inGroup(group []string) bool {
+	for _, name := range group {
+		if i.self[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// Heal lifts all partitions
+func (i *Injector) Heal() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.blocked = make(map[string]bool)
+}
+
+// Reset lifts all partitions and stops injecting message faults
+func (i *Injector) Reset() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.blocked = make(map[string]bool)
+	i.faults = Faults{}
+}
+
+// KillConnections closes up to n random peer connections and returns how
+// many it closed
+func (i *Injector) KillConnections(n int) int {
+	i.mu.Lock()
+	peers := i.matchingLocked(func(*netp2p.TCPPeer) bool { return true })
+	// Sorted first so a seeded injector picks the same victims
+	sort.Slice(peers, func(a, b int) bool { return peers[a].RemoteAddr().String() < peers[b].RemoteAddr().String() })
+	i.rng.Shuffle(len(peers), func(a, b int) { peers[a], peers[b] = peers[b], peers[a] })
+	i.mu.Unlock()
+
+	if n < len(peers) {
+		peers = peers[:n]
+	}
+	for _, p := range peers {
+		i.kill(p, "killed")
+	}
+	return len(peers)
+}
+
+// Peers returns the addresses of the connected peers
+func (i *Injector) Peers() []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	addrs := make([]string, 0, len(i.peers))
+	for p := range i.peers {
+		addrs = append(addrs, p.RemoteAddr().String())
+	}
+	return addrs
+}
+
+func (i *Injector) kill(p *netp2p.TCPPeer, reason string) {
+	i.logger.Info("Killing peer connection", "peer", p.RemoteAddr(), "reason", reason)
+	if err := p.Close(); err == nil {
+		i.mu.Lock()
+		i.stats.Killed++
+		i.mu.Unlock()
+	}
+}
+
+func (i *Injector) matchingLocked(match func(*netp2p.TCPPeer) bool) []*netp2p.TCPPeer {
+	var peers []*netp2p.TCPPeer
+	for p := range i.peers {
+		if match(p) {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// isBlockedLocked reports whether the peer is named by a partition
+func (i *Injector) isBlockedLocked(p *netp2p.TCPPeer) bool {
+	if len(i.blocked) == 0 {
+		return false
+	}
+	if id := p.NodeID(); id != "" && i.blocked[id] {
+		return true
+	}
+	addr := p.RemoteAddr().String()
+	if i.blocked[addr] {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && i.blocked[host]
+}
+
+// OnConnect implements netp2p.ConnHook
+func (i *Injector) OnConnect(p *netp2p.TCPPeer) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.isBlockedLocked(p) {
+		i.stats.Refused++
+		return errPartitioned
+	}
+	i.peers[p] = struct{}{}
+	return nil
+}
+
+// OnDisconnect implements netp2p.ConnHook
+func (i *Injector) OnDisconnect(p *netp2p.TCPPeer) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.peers, p)
+}
+
+// OnMessage implements netp2p.ConnHook. Delays hold up the connection's
+// read loop, so later messages from the same peer queue behind a delayed
+// one, as they would on a slow TCP link.
+func (i *Injector) OnMessage(p *netp2p.TCPPeer, rpc netp2p.RPC) []netp2p.RPC {
+	i.mu.Lock()
+	i.stats.Messages++
+	if i.isBlockedLocked(p) {
+		i.stats.Dropped++
+		i.mu.Unlock()
+		return nil
+	}
+	f := i.faults
+	if i.hit(f.Drop) {
+		i.stats.Dropped++
+		i.mu.Unlock()
+		return nil
+	}
+	if i.hit(f.Corrupt) && len(rpc.Payload) > 0 {
+		i.stats.Corrupted++
+		payload := append([]byte(nil), rpc.Payload...)
+		payload[i.rng.IntN(len(payload))] ^= byte(1 + i.rng.IntN(255))
+		rpc.Payload = payload
+	}
+	var delay time.Duration
+	if i.hit(f.Delay) {
+		i.stats.Delayed++
+		delay = f.MinDelay
+		if f.MaxDelay > f.MinDelay {
+			delay += time.Duration(i.rng.Int64N(int64(f.MaxDelay - f.MinDelay)))
+		}
+	}
+	copies := 1
+	if i.hit(f.Duplicate) {
+		i.stats.Duplicated++
+		copies = 2
+	}
+	i.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	delivered := make([]netp2p.RPC, copies)
+	for n := range delivered {
+		delivered[n] = rpc
+	}
+	return delivered
+}
+
+// hit draws whether a fault with probability p happens
+func (i *Injector) hit(p float64) bool {
+	return p > 0 && i.rng.Float64() < p
+}
@@ -0,0 +1,197 @@
+package chaos
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pipePeer(t *testing.T) *netp2p.TCPPeer {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		_ = a.Close()
+		_ = b.Close()
+	})
+	return netp2p.NewTCPPeer(a, true)
+}
+
+func newTestInjector(t *testing.T, opts Options) *Injector {
+	t.Helper()
+	opts.Seed = 1
+	i, err := New(opts)
+	require.NoError(t, err)
+	return i
+}
+
+func TestAvailableInTests(t *testing.T) {
+	assert.True(t, Available())
+}
+
+func TestMessageFaults(t *testing.T) {
+	peer := pipePeer(t)
+	rpc := netp2p.RPC{From: "peer", Payload: []byte("hello")}
+
+	i := newTestInjector(t, Options{})
+	assert.Equal(t, []netp2p.RPC{rpc}, i.OnMessage(peer, rpc))
+
+	require.NoError(t, i.SetFaults(Faults{Drop: 1}))
+	assert.Empty(t, i.OnMessage(peer, rpc))
+
+	require.NoError(t, i.SetFaults(Faults{Duplicate: 1}))
+	assert.Len(t, i.OnMessage(peer, rpc), 2)
+
+	require.NoError(t, i.SetFaults(Faults{Corrupt: 1}))
+	got := i.OnMessage(peer, rpc)
+	require.Len(t, got, 1)
+	assert.NotEqual(t, rpc.Payload, got[0].Payload)
+	assert.Equal(t, "hello", string(rpc.Payload), "the original payload is left alone")
+
+	require.NoError(t, i.SetFaults(Faults{Delay: 1, MinDelay: 20 * time.Millisecond, MaxDelay: 30 * time.Millisecond}))
+	start := time.Now()
+	assert.Len(t, i.OnMessage(peer, rpc), 1)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	stats := i.Stats()
+	assert.Equal(t, int64(5), stats.Messages)
+	assert.Equal(t, int64(1), stats.Dropped)
+	assert.Equal(t, int64(1), stats.Duplicated)
+	assert.Equal(t, int64(1), stats.Corrupted)
+	assert.Equal(t, int64(1), stats.Delayed)
+
+	assert.Error(t, i.SetFaults(Faults{Drop: 2}))
+	assert.Error(t, i.SetFaults(Faults{MinDelay: time.Second}))
+}
+
+func TestPartitionKillsAndRefusesPeers(t *testing.T) {
+	i := newTestInjector(t, Options{Self: []string{"node-a"}})
+	peer := pipePeer(t)
+	peer.SetNodeID("node-c")
+	other := pipePeer(t)
+	other.SetNodeID("node-b")
+	require.NoError(t, i.OnConnect(peer))
+	require.NoError(t, i.OnConnect(other))
+
+	i.Partition([]string{"node-a", "node-b"}, []string{"node-c"})
+	assert.Equal(t, int64(1), i.Stats().Killed)
+	assert.Empty(t, i.OnMessage(peer, netp2p.RPC{Payload: []byte("late")}))
+	assert.Len(t, i.OnMessage(other, netp2p.RPC{Payload: []byte("ok")}), 1)
+
+	assert.ErrorIs(t, i.OnConnect(peer), errPartitioned)
+	assert.Equal(t, int64(1), i.Stats().Refused)
+
+	i.Heal()
+	assert.NoError(t, i.OnConnect(peer))
+}
+
+func TestPartitionWithoutSelfBlocksNobody(t *testing.T) {
+	i := newTestInjector(t, Options{Self: []string{"node-z"}})
+	peer := pipePeer(t)
+	peer.SetNodeID("node-c")
+	i.Partition([]string{"node-a"}, []string{"node-c"})
+	assert.NoError(t, i.OnConnect(peer))
+}
+
+// Two real transports: the injector on one side kills and refuses the
+// connection to the other
+func TestInjectorOnTransport(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	i := newTestInjector(t, Options{})
+	received := make(chan struct{}, 1)
+	server := netp2p.NewTCPTransport(netp2p.TCPTransportOpts{
+		ListenAddr:    addr,
+		HandshakeFunc: netp2p.AuthenticatedHandshakeFunc("server"),
+		Hook:          i,
+		OnPeer: func(netp2p.Peer) error {
+			received <- struct{}{}
+			return nil
+		},
+	})
+	require.NoError(t, server.ListenAndAccept())
+	t.Cleanup(func() { _ = server.Close() })
+
+	client := netp2p.NewTCPTransport(netp2p.TCPTransportOpts{
+		ListenAddr:    "127.0.0.1:0",
+		HandshakeFunc: netp2p.AuthenticatedHandshakeFunc("client"),
+	})
+	require.NoError(t, client.Dial(addr))
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer never connected")
+	}
+	assert.Eventually(t, func() bool { return len(i.Peers()) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	i.Block("client")
+	assert.Eventually(t, func() bool { return len(i.Peers()) == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, client.Dial(addr))
+	assert.Eventually(t, func() bool { return i.Stats().Refused == 1 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestRunExperiment(t *testing.T) {
+	i := newTestInjector(t, Options{Self: []string{"a"}})
+	peer := pipePeer(t)
+	peer.SetNodeID("b")
+	require.NoError(t, i.OnConnect(peer))
+
+	exp := &Experiment{Name: "test", Steps: []Step{
+		{Faults: &Faults{Drop: 0.5}},
+		{After: time.Millisecond, Partition: [][]string{{"a"}, {"b"}}},
+	}}
+	require.NoError(t, i.Run(context.Background(), exp))
+	assert.Equal(t, int64(1), i.Stats().Killed)
+
+	// The injector is reset afterwards
+	assert.Equal(t, Faults{}, i.Faults())
+	assert.NoError(t, i.OnConnect(peer))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	repeating := &Experiment{Name: "loop", Repeat: true, Steps: []Step{{After: time.Millisecond, Kill: 1}}}
+	assert.ErrorIs(t, i.Run(ctx, repeating), context.DeadlineExceeded)
+}
+
+func TestLoadExperiment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exp.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+name: split-brain
+steps:
+  - faults: {drop: 0.1, delay: 0.2, min_delay: 50ms, max_delay: 500ms}
+  - after: 30s
+    partition:
+      - [node-a, node-b]
+      - [node-c]
+  - after: 1m
+    heal: true
+    kill: 2
+`), 0644))
+
+	exp, err := LoadExperiment(path)
+	require.NoError(t, err)
+	assert.Equal(t, "split-brain", exp.Name)
+	require.Len(t, exp.Steps, 3)
+	assert.Equal(t, 500*time.Millisecond, exp.Steps[0].Faults.MaxDelay)
+	assert.Equal(t, [][]string{{"node-a", "node-b"}, {"node-c"}}, exp.Steps[1].Partition)
+	assert.Equal(t, time.Minute, exp.Steps[2].After)
+
+	invalid := []*Experiment{
+		{Name: "empty"},
+		{Name: "bad faults", Steps: []Step{{Faults: &Faults{Corrupt: -1}}}},
+		{Name: "busy loop", Repeat: true, Steps: []Step{{Kill: 1}}},
+	}
+	for _, exp := range invalid {
+		assert.Error(t, exp.Validate(), exp.Name)
+	}
+}
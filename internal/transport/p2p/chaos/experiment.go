@@ -0,0 +1,154 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Experiment is a script of faults. Steps run in order, each After the
+// previous one; the injector is reset when the experiment ends.
+type Experiment struct {
+	Name  string `yaml:"name" json:"name"`
+	Steps []Step `yaml:"steps" json:"steps"`
+	// Repeat runs the steps again until the context is cancelled
+	Repeat bool `yaml:"repeat" json:"repeat"`
+}
+
+// Step changes the faults of the injector. Its fields apply in the order
+// they are declared.
+type Step struct {
+	// After is how long to wait before the step
+	After time.Duration `yaml:"after" json:"after"`
+	// Heal lifts the partitions of earlier steps
+	Heal bool `yaml:"heal" json:"heal"`
+	// Faults replace the message faults; nil keeps them
+	Faults *Faults `yaml:"faults" json:"faults"`
+	// Partition splits the cluster into groups of node IDs or addresses
+	Partition [][]string `yaml:"partition" json:"partition"`
+	// Block cuts this node off from the listed peers
+	Block []string `yaml:"block" json:"block"`
+	// Kill closes this many random peer connections
+	Kill int `yaml:"kill" json:"kill"`
+}
+
+// Validate checks the steps of the experiment
+func (e *Experiment) Validate() error {
+	if len(e.Steps) == 0 {
+		return fmt.Errorf("chaos: experiment %q has no steps", e.Name)
+	}
+	var total time.Duration
+	for n, step := range e.Steps {
+		if step.After < 0 {
+			return fmt.Errorf("chaos: step %d of %q: negative wait", n+1, e.Name)
+		}
+		if step.Kill < 0 {
+			return fmt.Errorf("chaos: step %d of %q: negative kill count", n+1, e.Name)
+		}
+		if step.Faults != nil {
+			if err := step.Faults.Validate(); err != nil {
+				return fmt.Errorf("step %d of %q: %w", n+1, e.Name, err)
+			}
+		}
+		total += step.After
+	}
+	if e.Repeat && total == 0 {
+		return fmt.Errorf("chaos: repeating experiment %q never waits", e.Name)
+	}
+	return nil
+}
+
+// LoadExperiment reads an experiment from a YAML file
+func LoadExperiment(path string) (*Experiment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var e Experiment
+	if err := yaml.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("chaos: failed to parse %s: %w", path, err)
+	}
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Run plays the experiment until its last step, or until ctx is done when
+// it repeats. The injector is reset afterwards either way.
+func (i *Injector) Run(ctx context.Context, e *Experiment) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	defer i.Reset()
+	i.logger.Info("Starting chaos experiment", "experiment", e.Name)
+
+	for {
+		for n, step := range e.Steps {
+			if err := sleep(ctx, step.After); err != nil {
+				return err
+			}
+			i.logger.Info("Chaos experiment step", "experiment", e.Name, "step", n+1)
+			i.apply(step)
+		}
+		if !e.Repeat {
+			i.logger.Info("Chaos experiment finished", "experiment", e.Name)
+			return nil
+		}
+	}
+}
+
+func (i *Injector) apply(step Step) {
+	if step.Heal {
+		i.Heal()
+	}
+	if step.Faults != nil {
+		// Validated with the experiment
+		_ = i.SetFaults(*step.Faults)
+	}
+	if len(step.Partition) > 0 {
+		i.Partition(step.Partition...)
+	}
+	if len(step.Block) > 0 {
+		i.Block(step.Block...)
+	}
+	if step.Kill > 0 {
+		i.KillConnections(step.Kill)
+	}
+}
+
+// KillEvery closes one random peer connection every interval until ctx is
+// done
+func (i *Injector) KillEvery(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("chaos: kill interval must be positive")
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			i.KillConnections(1)
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -1,29 +1,66 @@
 package p2p
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io"
+
+	"github.com/Skpow1234/Peervault/internal/codec"
 )
 
 const (
 	IncomingMessage = 0x1
 	IncomingStream  = 0x2
+	// IncomingEnvelope frames carry a message in a versioned envelope:
+	// [version:u8][message] in version 2, [version:u8][codec:u8][message]
+	// from version 3 on; version 4 seals the message, see seal.go
+	IncomingEnvelope = 0x3
+)
+
+// Protocol versions peers negotiate in the handshake. Version 1 sends
+// messages as bare IncomingMessage frames; version 2 wraps them in
+// envelopes naming the version their payload is encoded for, so the
+// encoding can change in later versions while older nodes remain in the
+// cluster. Version 3 adds the codec of the message to the envelope, and
+// version 4 seals it with the keys of the link on links that have them.
+const (
+	ProtocolVersion1 uint8 = 1
+	ProtocolVersion2 uint8 = 2
+	ProtocolVersion3 uint8 = 3
+	ProtocolVersion4 uint8 = 4
+
+	// MinProtocolVersion and CurrentProtocolVersion bound the versions
+	// this node speaks
+	MinProtocolVersion     = ProtocolVersion1
+	CurrentProtocolVersion = ProtocolVersion4
 )
 
 // Frame header structure: [type:u8][len:u32]
 const (
 	FrameHeaderSize = 5                // 1 byte type + 4 bytes length
 	MaxFrameSize    = 10 * 1024 * 1024 // 10MB max frame size
+
+	// payloadChunkSize is the memory reserved for a payload up front
+	payloadChunkSize = 64 * 1024
 )
 
+// ErrMalformedFrame is wrapped by the errors of frames that break the
+// framing rules, as opposed to failed reads
+var ErrMalformedFrame = errors.New("malformed frame")
+
 // RPC holds any arbitrary data that is being sent over the
 // each transport between two nodes in the network.
 type RPC struct {
 	From    string
 	Payload []byte
 	Stream  bool
+	// Version is the protocol version the payload is encoded for
+	Version uint8
+	// Codec is the ID of the codec the payload is encoded with
+	Codec uint8
 }
 
 type Decoder interface {
@@ -37,13 +74,21 @@ func (dec GOBDecoder) Decode(r io.Reader, msg *RPC) error {
 }
 
 // LengthPrefixedDecoder implements proper message framing
-type LengthPrefixedDecoder struct{}
+type LengthPrefixedDecoder struct {
+	// MaxSize is the largest payload accepted; zero or anything above
+	// MaxFrameSize means MaxFrameSize
+	MaxSize int
+}
 
-// Decode reads a length-prefixed frame from the reader
+// Decode reads a length-prefixed frame from the reader. The frame comes
+// from the network: every field of msg is reset, lengths are checked
+// before anything is allocated, and frames breaking the framing rules
+// fail with ErrMalformedFrame.
 func (dec LengthPrefixedDecoder) Decode(r io.Reader, msg *RPC) error {
 	if r == nil {
 		return fmt.Errorf("reader is nil")
 	}
+	*msg = RPC{}
 
 	// Read frame header: [type:u8][len:u32]
 	header := make([]byte, FrameHeaderSize)
@@ -58,32 +103,62 @@ func (dec LengthPrefixedDecoder) Decode(r io.Reader, msg *RPC) error {
 	payloadLen := binary.BigEndian.Uint32(header[1:])
 
 	// Validate payload length
-	if payloadLen > MaxFrameSize {
-		return fmt.Errorf("frame too large: %d bytes (max: %d)", payloadLen, MaxFrameSize)
+	maxSize := MaxFrameSize
+	if dec.MaxSize > 0 && dec.MaxSize < MaxFrameSize {
+		maxSize = dec.MaxSize
+	}
+	if payloadLen > uint32(maxSize) {
+		return fmt.Errorf("%w: frame too large: %d bytes (max: %d)", ErrMalformedFrame, payloadLen, maxSize)
 	}
 
-	// Handle stream type
-	if msgType == IncomingStream {
+	switch msgType {
+	case IncomingStream:
+		// The stream follows the header; what it holds is up to OnStream
 		msg.Stream = true
 		return nil
+	case IncomingMessage, IncomingEnvelope:
+	default:
+		return fmt.Errorf("%w: unknown message type: %d", ErrMalformedFrame, msgType)
 	}
 
-	// Handle message type
-	if msgType == IncomingMessage {
-		msg.Stream = false
+	msg.Version, msg.Codec = ProtocolVersion1, codec.IDGob
 
-		// Read payload
-		if payloadLen > 0 {
-			msg.Payload = make([]byte, payloadLen)
-			if _, err := io.ReadFull(r, msg.Payload); err != nil {
-				return fmt.Errorf("failed to read payload: %w", err)
-			}
+	// Read payload. The buffer grows as the payload arrives, so a peer
+	// announcing a large frame without sending it holds no memory.
+	if payloadLen > 0 {
+		buf := bytes.NewBuffer(make([]byte, 0, min(int(payloadLen), payloadChunkSize)))
+		if _, err := io.CopyN(buf, r, int64(payloadLen)); err != nil {
+			return fmt.Errorf("failed to read payload: %w", err)
 		}
+		msg.Payload = buf.Bytes()
+	}
+	if msgType == IncomingEnvelope {
+		return openEnvelope(msg)
+	}
+	return nil
+}
 
+// openEnvelope replaces the payload of msg with the message in its envelope
+func openEnvelope(msg *RPC) error {
+	if len(msg.Payload) == 0 {
+		return fmt.Errorf("%w: empty message envelope", ErrMalformedFrame)
+	}
+	version := msg.Payload[0]
+	if version < ProtocolVersion2 || version > CurrentProtocolVersion {
+		return fmt.Errorf("%w: unsupported protocol version %d", ErrMalformedFrame, version)
+	}
+	msg.Version, msg.Payload = version, msg.Payload[1:]
+	if version < ProtocolVersion3 {
 		return nil
 	}
-
-	return fmt.Errorf("unknown message type: %d", msgType)
+	if len(msg.Payload) == 0 {
+		return fmt.Errorf("%w: message envelope without codec", ErrMalformedFrame)
+	}
+	if _, ok := codec.ByID(msg.Payload[0]); !ok {
+		return fmt.Errorf("%w: unsupported codec %d", ErrMalformedFrame, msg.Payload[0])
+	}
+	msg.Codec, msg.Payload = msg.Payload[0], msg.Payload[1:]
+	return nil
 }
 
 // DefaultDecoder is kept for backward compatibility but deprecated
@@ -127,34 +202,63 @@ func NewFrameWriter(writer io.Writer) *FrameWriter {
 	return &FrameWriter{writer: writer}
 }
 
-// WriteMessage writes a message with proper framing
+// versioned is implemented by peers that negotiated a protocol version
+// and codec
+type versioned interface {
+	ProtocolVersion() uint8
+	Codec() uint8
+}
+
+// PeerCodec returns the ID of the codec messages written to peer with
+// WriteMessage must be encoded with
+func PeerCodec(peer io.Writer) uint8 {
+	if v, ok := peer.(versioned); ok && v.ProtocolVersion() >= ProtocolVersion3 {
+		return v.Codec()
+	}
+	return codec.IDGob
+}
+
+// WriteMessage writes a message with proper framing. Messages to peers
+// that negotiated ProtocolVersion2 or later go in an envelope of that
+// version, which must be encoded with the codec PeerCodec names. Links
+// without keys never seal, so they stop at ProtocolVersion3.
 func (fw *FrameWriter) WriteMessage(payload []byte) error {
+	if peer, ok := fw.writer.(sealer); ok && peer.sealed() {
+		return peer.writeSealed(payload)
+	}
+	if peer, ok := fw.writer.(versioned); ok && peer.ProtocolVersion() >= ProtocolVersion2 {
+		return fw.WriteEnvelope(min(peer.ProtocolVersion(), ProtocolVersion3), peer.Codec(), payload)
+	}
 	return fw.writeFrame(IncomingMessage, payload)
 }
 
+// WriteEnvelope writes a message in an envelope of the given protocol
+// version; versions before ProtocolVersion3 only carry gob, and leave the
+// codec out
+func (fw *FrameWriter) WriteEnvelope(version, codecID uint8, payload []byte) error {
+	if version < ProtocolVersion3 {
+		return fw.writeFrame(IncomingEnvelope, append([]byte{version}, payload...))
+	}
+	return fw.writeFrame(IncomingEnvelope, append([]byte{version, codecID}, payload...))
+}
+
 // WriteStreamHeader writes a stream header
 func (fw *FrameWriter) WriteStreamHeader() error {
 	return fw.writeFrame(IncomingStream, nil)
 }
 
-// writeFrame writes a frame with the given type and payload
+// writeFrame writes a frame with the given type and payload. Header and
+// payload go out in a single Write, so frames written to the same
+// connection from several goroutines do not interleave.
 func (fw *FrameWriter) writeFrame(msgType byte, payload []byte) error {
-	// Create frame header: [type:u8][len:u32]
-	header := make([]byte, FrameHeaderSize)
-	header[0] = msgType
-	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
-
-	// Write header
-	if _, err := fw.writer.Write(header); err != nil {
-		return fmt.Errorf("failed to write frame header: %w", err)
+	// Create frame: [type:u8][len:u32][payload]
+	frame := make([]byte, FrameHeaderSize+len(payload))
+	frame[0] = msgType
+	binary.BigEndian.PutUint32(frame[1:FrameHeaderSize], uint32(len(payload)))
+	copy(frame[FrameHeaderSize:], payload)
+
+	if _, err := fw.writer.Write(frame); err != nil {
+		return fmt.Errorf("failed to write frame: %w", err)
 	}
-
-	// Write payload if present
-	if len(payload) > 0 {
-		if _, err := fw.writer.Write(payload); err != nil {
-			return fmt.Errorf("failed to write payload: %w", err)
-		}
-	}
-
 	return nil
 }
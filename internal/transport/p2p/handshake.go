@@ -1,14 +1,22 @@
 package p2p
 
 import (
+	"crypto/ed25519"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"slices"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Skpow1234/Peervault/internal/codec"
+	"github.com/Skpow1234/Peervault/internal/crypto"
 )
 
 // HandshakeFunc performs authentication between peers
@@ -22,62 +30,223 @@ type HandshakeMessage struct {
 	NodeID    string
 	Timestamp int64
 	Signature []byte
+	// PublicKey is the identity key of nodes that have one; KeySignature
+	// proves the sender holds it
+	PublicKey    []byte
+	KeySignature []byte
+	// MinVersion and MaxVersion are the protocol versions the sender
+	// speaks; nodes predating negotiation send neither and speak
+	// ProtocolVersion1 only
+	MinVersion uint8
+	MaxVersion uint8
+	// Codecs lists the IDs of the message codecs the sender speaks, most
+	// preferred first; without any, it speaks gob only
+	Codecs []uint8
+	// JoinToken is the ID of the join token a node joining the cluster
+	// presents; its Signature is then made with the token's secret
+	JoinToken string
+}
+
+// ErrJoined ends the handshake of a node that joined with a join token:
+// it was handed the cluster credentials and reconnects with them
+var ErrJoined = errors.New("p2p: node joined the cluster, closing the join connection")
+
+// HandshakeOptions configures a handshake
+type HandshakeOptions struct {
+	// Identity proves the node's identity key to its peers, presenting
+	// the key-derived ID as node ID
+	Identity *crypto.Identity
+	// Secret signs the handshake; empty uses PEERVAULT_AUTH_TOKEN, or the
+	// demo token without it
+	Secret string
+	// Join admits a node presenting a join token, whose handshake message
+	// is msg. It sends the node what it needs and returns ErrJoined, or
+	// the error refusing it. Without Join, join tokens are refused.
+	Join func(peer Peer, msg HandshakeMessage) error
+}
+
+// linkKeyHolder is implemented by peers that can retain the sub-keys derived for their link
+type linkKeyHolder interface {
+	SetLinkKeys(crypto.LinkKeys)
+}
+
+// nodeIDHolder is implemented by peers that can retain the node ID they authenticated as
+type nodeIDHolder interface {
+	SetNodeID(string)
+}
+
+// publicKeyHolder is implemented by peers that can retain the identity key they proved
+type publicKeyHolder interface {
+	SetPublicKey(ed25519.PublicKey)
+}
+
+// versionHolder is implemented by peers that can retain the protocol version and codec negotiated with them
+type versionHolder interface {
+	SetProtocolVersion(uint8)
+	SetCodec(uint8)
 }
 
 // AuthenticatedHandshakeFunc creates a handshake function that verifies peer identity
 func AuthenticatedHandshakeFunc(nodeID string) HandshakeFunc {
+	return NewHandshakeFunc(nodeID, HandshakeOptions{})
+}
+
+// IdentityHandshakeFunc creates a handshake function that also proves the
+// node's identity key to its peers, presenting the key-derived ID as node ID
+func IdentityHandshakeFunc(identity *crypto.Identity) HandshakeFunc {
+	return NewHandshakeFunc(identity.ID(), HandshakeOptions{Identity: identity})
+}
+
+// DefaultSecret returns the secret handshakes are signed with unless
+// configured: PEERVAULT_AUTH_TOKEN, or the demo token without it
+func DefaultSecret() string {
+	if authToken := os.Getenv("PEERVAULT_AUTH_TOKEN"); authToken != "" {
+		return authToken
+	}
+	// For demo purposes, use a default token if not set
+	return "demo-auth-token-2024"
+}
+
+// NewHandshakeFunc creates a handshake function for the node with the
+// options; with an identity, nodeID must be the identity's ID
+func NewHandshakeFunc(nodeID string, opts HandshakeOptions) HandshakeFunc {
+	identity := opts.Identity
 	return func(peer Peer) error {
-		// Get auth token from environment
-		authToken := os.Getenv("PEERVAULT_AUTH_TOKEN")
+		authToken := opts.Secret
 		if authToken == "" {
-			// For demo purposes, use a default token if not set
-			authToken = "demo-auth-token-2024"
-		}
-
-		// Create handshake message
-		msg := HandshakeMessage{
-			NodeID:    nodeID,
-			Timestamp: time.Now().Unix(),
+			authToken = DefaultSecret()
 		}
 
-		// Sign the message
-		msg.Signature = SignHandshakeMessage(msg, authToken)
+		// Create and sign the handshake message
+		msg := NewHandshakeMessage(nodeID, authToken, identity)
 
 		// Send handshake
-		if err := sendHandshake(peer, msg); err != nil {
+		if err := SendHandshake(peer, msg); err != nil {
 			return fmt.Errorf("failed to send handshake: %w", err)
 		}
 
 		// Receive and verify handshake
-		peerMsg, err := receiveHandshake(peer)
+		peerMsg, err := ReceiveHandshake(peer)
 		if err != nil {
 			return fmt.Errorf("failed to receive handshake: %w", err)
 		}
 
+		// A node joining the cluster signs with its join token instead
+		if peerMsg.JoinToken != "" {
+			if opts.Join == nil {
+				return fmt.Errorf("peer %s presented a join token, but this node does not admit nodes", peer.RemoteAddr())
+			}
+			return opts.Join(peer, peerMsg)
+		}
+
 		// Verify peer signature
 		if !VerifyHandshakeMessage(peerMsg, authToken) {
 			return fmt.Errorf("invalid handshake signature from peer %s", peer.RemoteAddr())
 		}
 
-		// Check timestamp (allow 30 second clock skew)
-		if time.Now().Unix()-peerMsg.Timestamp > 30 {
-			return fmt.Errorf("handshake timestamp too old from peer %s", peer.RemoteAddr())
+		// Check timestamp, allowing for clock skew either way
+		if skew := time.Now().Unix() - peerMsg.Timestamp; skew > MaxHandshakeSkew || skew < -MaxHandshakeSkew {
+			return fmt.Errorf("handshake timestamp of peer %s is %ds off", peer.RemoteAddr(), skew)
 		}
 
-		slog.Info("authenticated handshake with peer", slog.String("peer", peer.RemoteAddr().String()), slog.String("node", peerMsg.NodeID))
+		if err := VerifyHandshakeIdentity(peerMsg); err != nil {
+			return fmt.Errorf("peer %s: %w", peer.RemoteAddr(), err)
+		}
+
+		version, err := NegotiateVersion(peerMsg)
+		if err != nil {
+			return fmt.Errorf("peer %s: %w", peer.RemoteAddr(), err)
+		}
+
+		// Bind stream encryption and control MAC keys to this specific link
+		if holder, ok := peer.(linkKeyHolder); ok {
+			keys, err := crypto.DeriveLinkKeys([]byte(authToken), nodeID, peerMsg.NodeID)
+			if err != nil {
+				return fmt.Errorf("failed to derive link keys: %w", err)
+			}
+			holder.SetLinkKeys(keys)
+		}
+		if holder, ok := peer.(nodeIDHolder); ok {
+			holder.SetNodeID(peerMsg.NodeID)
+		}
+		if holder, ok := peer.(publicKeyHolder); ok && len(peerMsg.PublicKey) > 0 {
+			holder.SetPublicKey(ed25519.PublicKey(peerMsg.PublicKey))
+		}
+		codecID := NegotiateCodec(peerMsg, version)
+		if holder, ok := peer.(versionHolder); ok {
+			holder.SetProtocolVersion(version)
+			holder.SetCodec(codecID)
+		}
+
+		slog.Info("authenticated handshake with peer", slog.String("peer", peer.RemoteAddr().String()), slog.String("node", peerMsg.NodeID),
+			slog.Int("protocol", int(version)), slog.Int("codec", int(codecID)))
 		return nil
 	}
 }
 
-// SignHandshakeMessage creates a signature for the handshake message
+// NewHandshakeMessage creates the handshake message of a node, signed with
+// the secret and, with an identity, its identity key
+func NewHandshakeMessage(nodeID, secret string, identity *crypto.Identity) HandshakeMessage {
+	msg := HandshakeMessage{
+		NodeID:     nodeID,
+		Timestamp:  time.Now().Unix(),
+		MinVersion: MinProtocolVersion,
+		MaxVersion: CurrentProtocolVersion,
+		Codecs:     codec.Supported(),
+	}
+	msg.Signature = SignHandshakeMessage(msg, secret)
+	if identity != nil {
+		msg.PublicKey = identity.PublicKey()
+		msg.KeySignature = identity.Sign(identityPayload(msg))
+	}
+	return msg
+}
+
+// NegotiateVersion picks the highest protocol version both this node and
+// the sender of msg speak. Both ends of a link arrive at the same version.
+func NegotiateVersion(msg HandshakeMessage) (uint8, error) {
+	peerMin, peerMax := msg.MinVersion, msg.MaxVersion
+	if peerMax == 0 {
+		peerMin, peerMax = ProtocolVersion1, ProtocolVersion1
+	}
+	version := min(peerMax, CurrentProtocolVersion)
+	if version < max(peerMin, MinProtocolVersion) {
+		return 0, fmt.Errorf("no common protocol version: peer speaks %d-%d, this node %d-%d",
+			peerMin, peerMax, MinProtocolVersion, CurrentProtocolVersion)
+	}
+	return version, nil
+}
+
+// NegotiateCodec picks the message codec for a link of the given protocol
+// version with the sender of msg: the first codec in the order of
+// codec.Supported that both ends speak. Both ends arrive at the same codec.
+func NegotiateCodec(msg HandshakeMessage, version uint8) uint8 {
+	if version < ProtocolVersion3 {
+		return codec.IDGob
+	}
+	for _, id := range codec.Supported() {
+		if slices.Contains(msg.Codecs, id) {
+			return id
+		}
+	}
+	return codec.IDGob
+}
+
+// SignHandshakeMessage creates a signature for the handshake message. The
+// auth token is never used directly; the HMAC key is a handshake-only sub-key.
 func SignHandshakeMessage(msg HandshakeMessage, authToken string) []byte {
-	h := hmac.New(sha256.New, []byte(authToken))
+	key, err := crypto.DeriveSubKey([]byte(authToken), crypto.PurposeHandshake, "")
+	if err != nil {
+		return nil
+	}
+	h := hmac.New(sha256.New, key)
 	h.Write([]byte(msg.NodeID))
 
 	// Write timestamp as 8 bytes
 	timestampBytes := make([]byte, 8)
 	binary.BigEndian.PutUint64(timestampBytes, uint64(msg.Timestamp))
 	h.Write(timestampBytes)
+	h.Write(negotiationPayload(msg))
 
 	return h.Sum(nil)
 }
@@ -85,11 +254,55 @@ func SignHandshakeMessage(msg HandshakeMessage, authToken string) []byte {
 // VerifyHandshakeMessage verifies the signature of a handshake message
 func VerifyHandshakeMessage(msg HandshakeMessage, authToken string) bool {
 	expectedSignature := SignHandshakeMessage(msg, authToken)
+	if expectedSignature == nil {
+		return false
+	}
 	return hmac.Equal(msg.Signature, expectedSignature)
 }
 
-// sendHandshake sends a handshake message to a peer
-func sendHandshake(peer Peer, msg HandshakeMessage) error {
+// VerifyHandshakeIdentity checks the identity key of a handshake message:
+// the sender must hold the key, and its node ID must be the key's. Messages
+// of nodes without an identity carry no key and pass.
+func VerifyHandshakeIdentity(msg HandshakeMessage) error {
+	if len(msg.PublicKey) == 0 {
+		return nil
+	}
+	if len(msg.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid identity key length %d", len(msg.PublicKey))
+	}
+	if !ed25519.Verify(msg.PublicKey, identityPayload(msg), msg.KeySignature) {
+		return fmt.Errorf("invalid identity signature from node %s", msg.NodeID)
+	}
+	if msg.NodeID != crypto.IdentityID(msg.PublicKey) {
+		return fmt.Errorf("node ID %s does not match its identity key", msg.NodeID)
+	}
+	return nil
+}
+
+// identityPayload is what the identity key signs: the node ID, timestamp,
+// key, protocol versions and codecs, under a label of their own
+func identityPayload(msg HandshakeMessage) []byte {
+	payload := []byte(crypto.SubKeyLabelPrefix + "handshake-identity")
+	payload = append(payload, msg.NodeID...)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(msg.Timestamp))
+	payload = append(payload, msg.PublicKey...)
+	return append(payload, negotiationPayload(msg)...)
+}
+
+// negotiationPayload is how the signatures of a message cover the protocol
+// versions and codecs it offers, so they cannot be rewritten to downgrade
+// the link. Messages of nodes predating negotiation offer none and sign as
+// before; the codecs are encoded as they are sent, after their count.
+func negotiationPayload(msg HandshakeMessage) []byte {
+	if msg.MaxVersion == 0 {
+		return nil
+	}
+	payload := []byte{msg.MinVersion, msg.MaxVersion, uint8(len(msg.Codecs))}
+	return append(payload, msg.Codecs...)
+}
+
+// SendHandshake sends a handshake message to a peer
+func SendHandshake(peer io.Writer, msg HandshakeMessage) error {
 	// Write message length
 	msgBytes := SerializeHandshakeMessage(msg)
 	length := uint32(len(msgBytes))
@@ -108,14 +321,17 @@ func sendHandshake(peer Peer, msg HandshakeMessage) error {
 	return nil
 }
 
-// receiveHandshake receives a handshake message from a peer
-func receiveHandshake(peer Peer) (HandshakeMessage, error) {
+// ReceiveHandshake receives a handshake message from a peer
+func ReceiveHandshake(peer io.Reader) (HandshakeMessage, error) {
 	// Read message length
 	lengthBytes := make([]byte, 4)
 	if _, err := io.ReadFull(peer, lengthBytes); err != nil {
 		return HandshakeMessage{}, err
 	}
 	length := binary.BigEndian.Uint32(lengthBytes)
+	if length > MaxHandshakeSize {
+		return HandshakeMessage{}, fmt.Errorf("handshake message too large: %d bytes", length)
+	}
 
 	// Read message
 	msgBytes := make([]byte, length)
@@ -128,12 +344,25 @@ func receiveHandshake(peer Peer) (HandshakeMessage, error) {
 
 // SerializeHandshakeMessage converts a handshake message to bytes
 func SerializeHandshakeMessage(msg HandshakeMessage) []byte {
-	// Simple serialization: nodeID length + nodeID + timestamp + signature length + signature
+	// Simple serialization: nodeID length + nodeID + timestamp + signature length + signature,
+	// followed by key length + key + key signature length + key signature when there is a key
+	// or a version range, and the lowest and highest version, codec count and codecs when there
+	// is a version range, then the join token length and join token when there is one
 	nodeIDBytes := []byte(msg.NodeID)
 	nodeIDLen := uint16(len(nodeIDBytes))
 	sigLen := uint16(len(msg.Signature))
 
+	withKey := len(msg.PublicKey) > 0 || msg.MaxVersion > 0
 	totalLen := 2 + len(nodeIDBytes) + 8 + 2 + len(msg.Signature)
+	if withKey {
+		totalLen += 2 + len(msg.PublicKey) + 2 + len(msg.KeySignature)
+	}
+	if msg.MaxVersion > 0 {
+		totalLen += 2 + 1 + len(msg.Codecs)
+		if msg.JoinToken != "" {
+			totalLen += 2 + len(msg.JoinToken)
+		}
+	}
 	result := make([]byte, totalLen)
 
 	offset := 0
@@ -156,6 +385,29 @@ func SerializeHandshakeMessage(msg HandshakeMessage) []byte {
 
 	// Signature
 	copy(result[offset:], msg.Signature)
+	offset += len(msg.Signature)
+
+	if withKey {
+		binary.BigEndian.PutUint16(result[offset:], uint16(len(msg.PublicKey)))
+		offset += 2
+		copy(result[offset:], msg.PublicKey)
+		offset += len(msg.PublicKey)
+		binary.BigEndian.PutUint16(result[offset:], uint16(len(msg.KeySignature)))
+		offset += 2
+		copy(result[offset:], msg.KeySignature)
+		offset += len(msg.KeySignature)
+	}
+	if msg.MaxVersion > 0 {
+		result[offset] = msg.MinVersion
+		result[offset+1] = msg.MaxVersion
+		result[offset+2] = uint8(len(msg.Codecs))
+		copy(result[offset+3:], msg.Codecs)
+		offset += 3 + len(msg.Codecs)
+		if msg.JoinToken != "" {
+			binary.BigEndian.PutUint16(result[offset:], uint16(len(msg.JoinToken)))
+			copy(result[offset+2:], msg.JoinToken)
+		}
+	}
 
 	return result
 }
@@ -176,9 +428,12 @@ func DeserializeHandshakeMessage(data []byte) (HandshakeMessage, error) {
 		return HandshakeMessage{}, fmt.Errorf("invalid nodeID length")
 	}
 
-	// NodeID
+	// NodeID, which ends up in logs and peer tables
 	nodeID := string(data[offset : offset+int(nodeIDLen)])
 	offset += int(nodeIDLen)
+	if err := validNodeID(nodeID); err != nil {
+		return HandshakeMessage{}, err
+	}
 
 	if offset+8 > len(data) {
 		return HandshakeMessage{}, fmt.Errorf("message too short for timestamp")
@@ -196,17 +451,95 @@ func DeserializeHandshakeMessage(data []byte) (HandshakeMessage, error) {
 	sigLen := binary.BigEndian.Uint16(data[offset:])
 	offset += 2
 
-	if offset+int(sigLen) != len(data) {
+	if offset+int(sigLen) > len(data) {
 		return HandshakeMessage{}, fmt.Errorf("invalid signature length")
 	}
 
 	// Signature
 	signature := make([]byte, sigLen)
 	copy(signature, data[offset:])
+	offset += int(sigLen)
 
-	return HandshakeMessage{
+	msg := HandshakeMessage{
 		NodeID:    nodeID,
 		Timestamp: timestamp,
 		Signature: signature,
-	}, nil
+	}
+	if offset == len(data) {
+		return msg, nil
+	}
+
+	// Identity key and its signature
+	publicKey, next, err := readField(data, offset)
+	if err != nil {
+		return HandshakeMessage{}, fmt.Errorf("invalid identity key: %w", err)
+	}
+	keySignature, next, err := readField(data, next)
+	if err != nil {
+		return HandshakeMessage{}, fmt.Errorf("invalid identity signature: %w", err)
+	}
+	if len(publicKey) > 0 {
+		msg.PublicKey, msg.KeySignature = publicKey, keySignature
+	}
+
+	// Protocol versions. Fields added by later versions follow them and
+	// are ignored here, so new nodes can still handshake with this one.
+	if next+2 > len(data) {
+		return msg, nil
+	}
+	msg.MinVersion, msg.MaxVersion = data[next], data[next+1]
+	if msg.MaxVersion == 0 || msg.MinVersion > msg.MaxVersion {
+		return HandshakeMessage{}, fmt.Errorf("invalid protocol versions %d-%d", msg.MinVersion, msg.MaxVersion)
+	}
+	next += 2
+
+	// Message codecs
+	if next < len(data) {
+		n := int(data[next])
+		next++
+		if next+n > len(data) {
+			return HandshakeMessage{}, fmt.Errorf("invalid codec count %d", n)
+		}
+		msg.Codecs = append([]uint8(nil), data[next:next+n]...)
+		next += n
+	}
+
+	// Join token of a node joining the cluster. Bytes that do not make
+	// one are left to later versions; without a token, the message must
+	// still carry the cluster's signature.
+	if token, _, err := readField(data, next); err == nil {
+		msg.JoinToken = string(token)
+	}
+	return msg, nil
+}
+
+// validNodeID checks a node ID received in a handshake: printable UTF-8,
+// not empty
+func validNodeID(id string) error {
+	if id == "" {
+		return fmt.Errorf("empty node ID")
+	}
+	if !utf8.ValidString(id) {
+		return fmt.Errorf("node ID is not valid UTF-8")
+	}
+	for _, r := range id {
+		if !unicode.IsPrint(r) {
+			return fmt.Errorf("node ID contains unprintable character %U", r)
+		}
+	}
+	return nil
+}
+
+// readField reads a length-prefixed field at offset, returning it and the
+// offset after it
+func readField(data []byte, offset int) ([]byte, int, error) {
+	if offset+2 > len(data) {
+		return nil, 0, fmt.Errorf("message too short for length")
+	}
+	n := int(binary.BigEndian.Uint16(data[offset:]))
+	offset += 2
+	if offset+n > len(data) {
+		return nil, 0, fmt.Errorf("length %d exceeds message", n)
+	}
+	return append([]byte(nil), data[offset:offset+n]...), offset + n, nil
 }
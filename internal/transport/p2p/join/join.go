@@ -0,0 +1,233 @@
+package join
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+)
+
+// CredentialsFileName is the file under the storage root keeping the
+// credentials of a node
+const CredentialsFileName = "cluster-credentials.json"
+
+// dialTimeout bounds joining through one address when the context has no
+// deadline
+const dialTimeout = 30 * time.Second
+
+// maxFrameSize bounds the sealed credentials a node accepts
+const maxFrameSize = 1 << 20
+
+// Join joins the cluster with an encoded token: it presents the token to
+// the issuing node as nodeID, proving identity when there is one, and
+// returns the credentials the node sends. The addresses of the token are
+// tried in turn.
+func Join(ctx context.Context, encoded, nodeID string, identity *crypto.Identity) (*Credentials, error) {
+	t, err := ParseToken(encoded)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, addr := range t.Addrs {
+		creds, err := joinAddr(ctx, t, addr, nodeID, identity)
+		if err == nil {
+			return creds, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("join: failed to join the cluster: %w", errors.Join(errs...))
+}
+
+func joinAddr(ctx context.Context, t Token, addr, nodeID string, identity *crypto.Identity) (*Credentials, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(dialTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	msg := netp2p.NewHandshakeMessage(nodeID, string(t.Secret), identity)
+	msg.JoinToken = t.ID
+	if err := netp2p.SendHandshake(conn, msg); err != nil {
+		return nil, err
+	}
+	issuer, err := netp2p.ReceiveHandshake(conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := netp2p.VerifyHandshakeIdentity(issuer); err != nil {
+		return nil, err
+	}
+	if t.Issuer != "" && issuer.NodeID != t.Issuer {
+		return nil, fmt.Errorf("reached node %s, not the issuer %s", issuer.NodeID, t.Issuer)
+	}
+
+	// The issuer closes the connection without credentials when it
+	// refuses the node
+	sealed, err := readFrame(conn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: no credentials received: %v", ErrRefused, err)
+	}
+	return open(t.Secret, nodeID, issuer.NodeID, sealed)
+}
+
+// sealKey derives the key sealing the credentials handed to joiner by
+// issuer
+func sealKey(secret []byte, joiner, issuer string) (cipher.AEAD, error) {
+	key, err := crypto.DeriveSubKey(secret, crypto.PurposeClusterJoin, joiner+"/"+issuer)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the credentials for joiner with the token's secret, so
+// only the holder of the token can read them
+func seal(secret []byte, joiner, issuer string, creds Credentials) ([]byte, error) {
+	gcm, err := sealKey(secret, joiner, issuer)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts credentials sealed by seal
+func open(secret []byte, joiner, issuer string, sealed []byte) (*Credentials, error) {
+	gcm, err := sealKey(secret, joiner, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("join: sealed credentials too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("join: credentials not sealed with the token")
+	}
+	var creds Credentials
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return nil, fmt.Errorf("join: invalid credentials: %w", err)
+	}
+	if creds.Secret == "" {
+		return nil, errors.New("join: credentials without a secret")
+	}
+	return &creds, nil
+}
+
+// writeFrame writes data prefixed with its length
+func writeFrame(w io.Writer, data []byte) error {
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	_, err := w.Write(append(frame, data...))
+	return err
+}
+
+// readFrame reads data written by writeFrame
+func readFrame(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n > maxFrameSize {
+		return nil, fmt.Errorf("frame too large: %d bytes", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// LoadCredentials reads credentials saved by SaveCredentials; the error
+// wraps os.ErrNotExist when there are none
+func LoadCredentials(path string) (*Credentials, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var creds Credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("join: corrupt credentials %s: %w", path, err)
+	}
+	if creds.Secret == "" {
+		return nil, fmt.Errorf("join: credentials %s without a secret", path)
+	}
+	return &creds, nil
+}
+
+// SaveCredentials writes credentials readable by the owner only
+func SaveCredentials(path string, creds *Credentials) error {
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// NewCredentials creates the credentials of a node bootstrapping a cluster:
+// the given secret and hex-encoded cluster key, or random ones when empty
+func NewCredentials(secret, clusterKey string) (*Credentials, error) {
+	var err error
+	if secret == "" {
+		if secret, err = randomHex(secretSize); err != nil {
+			return nil, err
+		}
+	}
+	if clusterKey == "" {
+		if clusterKey, err = randomHex(32); err != nil {
+			return nil, err
+		}
+	}
+	return &Credentials{Secret: secret, ClusterKey: clusterKey, JoinedAt: time.Now().UTC()}, nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
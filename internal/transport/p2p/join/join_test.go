@@ -0,0 +1,187 @@
+package join
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// issuer serves the handshake of a cluster node admitting nodes with reg
+func issuer(t *testing.T, reg *Registry, nodeID string) (string, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	handshake := netp2p.NewHandshakeFunc(nodeID, netp2p.HandshakeOptions{Secret: "cluster-secret", Join: reg.Admit})
+	results := make(chan error, 8)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			results <- handshake(netp2p.NewTCPPeer(conn, false))
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String(), results
+}
+
+func newRegistry(t *testing.T, opts Options) *Registry {
+	t.Helper()
+	opts.NodeID = "issuer"
+	opts.Credentials = func() Credentials {
+		return Credentials{Secret: "cluster-secret", ClusterKey: "00ff", Peers: []string{"10.0.0.1:3000"}}
+	}
+	reg, err := New(opts)
+	require.NoError(t, err)
+	return reg
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	tok := Token{ID: "abc", Secret: make([]byte, secretSize), Addrs: []string{"host:3000"}, Issuer: "n1"}
+	parsed, err := ParseToken(tok.String())
+	require.NoError(t, err)
+	assert.Equal(t, tok, parsed)
+
+	for _, s := range []string{"", "abc", tokenPrefix + "!!", (Token{ID: "abc", Secret: []byte("short"), Addrs: []string{"host:3000"}}).String()} {
+		_, err := ParseToken(s)
+		assert.ErrorIs(t, err, ErrInvalidToken, s)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "join-tokens.json")
+	reg := newRegistry(t, Options{Path: path})
+	addr, results := issuer(t, reg, "issuer")
+	reg.opts.Addrs = []string{addr}
+
+	record, tok, err := reg.Create(context.Background(), CreateOptions{MaxUses: 1, Comment: "edge"}, "admin")
+	require.NoError(t, err)
+	assert.Equal(t, "issuer", tok.Issuer)
+
+	identity, err := crypto.NewIdentity()
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	creds, err := Join(ctx, tok.String(), identity.ID(), identity)
+	require.NoError(t, err)
+	assert.ErrorIs(t, <-results, netp2p.ErrJoined)
+	assert.Equal(t, "cluster-secret", creds.Secret)
+	assert.Equal(t, "00ff", creds.ClusterKey)
+	assert.Equal(t, []string{"10.0.0.1:3000"}, creds.Peers)
+	assert.Equal(t, "issuer", creds.Issuer)
+
+	records := reg.Tokens()
+	require.Len(t, records, 1)
+	assert.Equal(t, record.ID, records[0].ID)
+	assert.Equal(t, 1, records[0].Uses)
+	require.Len(t, records[0].Joined, 1)
+	assert.Equal(t, identity.ID(), records[0].Joined[0].NodeID)
+	assert.True(t, records[0].Joined[0].Verified)
+
+	// The token is used up
+	_, err = Join(ctx, tok.String(), "n2", nil)
+	assert.ErrorIs(t, err, ErrRefused)
+	assert.ErrorIs(t, <-results, ErrRefused)
+
+	// The tokens survive a restart
+	reloaded := newRegistry(t, Options{Path: path})
+	assert.Equal(t, records, reloaded.Tokens())
+
+	// Revoked tokens are forgotten
+	require.NoError(t, reg.Revoke(context.Background(), record.ID, "admin"))
+	assert.ErrorIs(t, reg.Revoke(context.Background(), record.ID, "admin"), ErrNotFound)
+	assert.Empty(t, reg.Tokens())
+}
+
+func TestAdmitRefuses(t *testing.T) {
+	now := time.Now()
+	denied := errors.New("denied by ACL")
+	reg := newRegistry(t, Options{
+		Now: func() time.Time { return now },
+		Check: func(nodeID, addr string, verified bool) error {
+			if nodeID == "blocked" {
+				return denied
+			}
+			return nil
+		},
+	})
+	addr, results := issuer(t, reg, "issuer")
+	reg.opts.Addrs = []string{addr}
+	_, tok, err := reg.Create(context.Background(), CreateOptions{TTL: time.Hour}, "admin")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The peer ACL applies to joining nodes
+	_, err = Join(ctx, tok.String(), "blocked", nil)
+	assert.ErrorIs(t, err, ErrRefused)
+	assert.ErrorIs(t, <-results, denied)
+
+	// A token with another secret is refused
+	forged := tok
+	forged.Secret = make([]byte, secretSize)
+	_, err = Join(ctx, forged.String(), "n1", nil)
+	assert.ErrorIs(t, err, ErrRefused)
+	assert.ErrorContains(t, <-results, "invalid signature")
+
+	assert.Zero(t, reg.Tokens()[0].Uses)
+
+	// A node reaching another node than the issuer drops what it is sent
+	other := tok
+	other.Issuer = "someone-else"
+	_, err = Join(ctx, other.String(), "n1", nil)
+	assert.ErrorContains(t, err, "not the issuer")
+	<-results
+
+	// Expired tokens are refused
+	now = now.Add(2 * time.Hour)
+	_, err = Join(ctx, tok.String(), "n2", nil)
+	assert.ErrorIs(t, err, ErrRefused)
+	assert.ErrorContains(t, <-results, "expired")
+}
+
+func TestHandshakeRefusesJoinWithoutRegistry(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	results := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		results <- netp2p.NewHandshakeFunc("n1", netp2p.HandshakeOptions{})(netp2p.NewTCPPeer(conn, false))
+	}()
+	tok := Token{ID: "abc", Secret: make([]byte, secretSize), Addrs: []string{ln.Addr().String()}}
+	_, err = Join(context.Background(), tok.String(), "n2", nil)
+	assert.ErrorIs(t, err, ErrRefused)
+	assert.ErrorContains(t, <-results, "does not admit nodes")
+}
+
+func TestCredentialsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), CredentialsFileName)
+	_, err := LoadCredentials(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	creds, err := NewCredentials("", "")
+	require.NoError(t, err)
+	assert.Len(t, creds.Secret, 2*secretSize)
+	assert.Len(t, creds.ClusterKey, 64)
+	require.NoError(t, SaveCredentials(path, creds))
+	loaded, err := LoadCredentials(path)
+	require.NoError(t, err)
+	assert.Equal(t, creds.Secret, loaded.Secret)
+	assert.Equal(t, creds.ClusterKey, loaded.ClusterKey)
+}
@@ -0,0 +1,406 @@
+// Package join admits new nodes to a cluster with join tokens. An operator
+// creates a time-limited token on a node of the cluster and hands it to the
+// new node, which presents it in the handshake instead of the cluster
+// secret. The issuing node checks the token and the peer ACL, and sends the
+// node the cluster credentials sealed with the token's secret: the secret
+// signing handshakes, the cluster key, and the addresses of the cluster.
+// The node keeps them and reconnects as a member; nodes without the
+// credentials cannot join by knowing an address.
+package join
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/audit"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+)
+
+var (
+	// ErrInvalidToken is returned for malformed join tokens
+	ErrInvalidToken = errors.New("join: invalid token")
+	// ErrNotFound is returned for tokens that do not exist
+	ErrNotFound = errors.New("join: token not found")
+	// ErrRefused is returned for nodes a token does not admit
+	ErrRefused = errors.New("join: node refused")
+)
+
+// DefaultTTL is how long tokens are valid unless created otherwise
+const DefaultTTL = 24 * time.Hour
+
+// secretSize is the size of token secrets
+const secretSize = 32
+
+// maxSkew is how old the handshake of a joining node may be
+const maxSkew = 30 * time.Second
+
+// Credentials are what a node needs to be a member of the cluster
+type Credentials struct {
+	// Secret signs the handshakes of the cluster
+	Secret string `json:"secret"`
+	// ClusterKey is the hex-encoded key stored files are encrypted with
+	ClusterKey string `json:"cluster_key,omitempty"`
+	// Peers are the P2P addresses of the cluster to connect to
+	Peers []string `json:"peers,omitempty"`
+	// Issuer is the node that admitted this one, empty on the node that
+	// bootstrapped the cluster
+	Issuer   string    `json:"issuer,omitempty"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// Member is a node admitted with a token
+type Member struct {
+	NodeID string `json:"node_id"`
+	Addr   string `json:"addr"`
+	// Verified reports whether the node proved an identity key
+	Verified bool      `json:"verified"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// Record describes a token; its secret is only ever returned once, by
+// Create
+type Record struct {
+	ID        string    `json:"id"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// MaxUses is how many nodes may join with the token; 0 is unlimited
+	MaxUses int `json:"max_uses,omitempty"`
+	Uses    int `json:"uses"`
+	// Joined are the nodes admitted with the token
+	Joined []Member `json:"joined,omitempty"`
+}
+
+// Expired reports whether the token is no longer valid at now
+func (r Record) Expired(now time.Time) bool {
+	return !now.Before(r.ExpiresAt)
+}
+
+// CreateOptions configures a new token
+type CreateOptions struct {
+	// TTL is how long the token is valid; 0 uses the TTL of the registry
+	TTL time.Duration
+	// MaxUses is how many nodes may join with the token; 0 is unlimited
+	MaxUses int
+	Comment string
+}
+
+// Options configures a Registry
+type Options struct {
+	// NodeID is the ID of this node, which joining nodes check they reached
+	NodeID string
+	// Addrs are the P2P addresses tokens point joining nodes at
+	Addrs []string
+	// Credentials returns the credentials handed to admitted nodes
+	Credentials func() Credentials
+	// Check is consulted before admitting a node, such as the peer ACL;
+	// nil admits every node holding a valid token
+	Check func(nodeID, addr string, verified bool) error
+	// TTL is how long tokens are valid unless created otherwise; 0 uses
+	// DefaultTTL
+	TTL time.Duration
+	// Path persists the tokens; empty keeps them in memory
+	Path string
+	// Audit receives the token changes and admissions; nil uses the
+	// global audit logger
+	Audit *audit.AuditLogger
+	// Now returns the current time; nil uses time.Now
+	Now func() time.Time
+}
+
+// token is a record with its secret, as kept by the registry
+type token struct {
+	Record
+	Secret []byte `json:"secret"`
+}
+
+// Registry holds the join tokens of a node and admits the nodes presenting
+// them
+type Registry struct {
+	opts Options
+
+	mu     sync.Mutex
+	tokens []token
+}
+
+// New returns a registry with the tokens persisted at opts.Path
+func New(opts Options) (*Registry, error) {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.Credentials == nil {
+		return nil, errors.New("join: no credentials to hand out")
+	}
+	r := &Registry{opts: opts}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Tokens returns the records of the tokens, oldest first
+func (r *Registry) Tokens() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	records := make([]Record, len(r.tokens))
+	for i, t := range r.tokens {
+		records[i] = t.clone()
+	}
+	return records
+}
+
+// Create creates a token. The returned Token is the only copy of its
+// secret that leaves the registry. Expired tokens are dropped.
+func (r *Registry) Create(ctx context.Context, opts CreateOptions, actor string) (Record, Token, error) {
+	if opts.TTL < 0 || opts.MaxUses < 0 {
+		return Record{}, Token{}, fmt.Errorf("%w: negative TTL or uses", ErrInvalidToken)
+	}
+	if opts.TTL == 0 {
+		opts.TTL = r.opts.TTL
+	}
+	if len(r.opts.Addrs) == 0 {
+		return Record{}, Token{}, errors.New("join: no address for joining nodes to reach this node")
+	}
+	secret := make([]byte, secretSize)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return Record{}, Token{}, err
+	}
+	now := r.opts.Now().UTC()
+	t := token{
+		Record: Record{
+			ID:        crypto.GenerateID()[:16],
+			Comment:   opts.Comment,
+			CreatedAt: now,
+			ExpiresAt: now.Add(opts.TTL),
+			MaxUses:   opts.MaxUses,
+		},
+		Secret: secret,
+	}
+
+	r.mu.Lock()
+	previous := r.tokens
+	r.tokens = slices.DeleteFunc(slices.Clone(r.tokens), func(t token) bool { return t.Expired(now) })
+	r.tokens = append(r.tokens, t)
+	err := r.saveLocked()
+	if err != nil {
+		r.tokens = previous
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return Record{}, Token{}, err
+	}
+
+	r.auditChange(ctx, "create_join_token", t.Record, actor)
+	return t.clone(), Token{ID: t.ID, Secret: secret, Addrs: slices.Clone(r.opts.Addrs), Issuer: r.opts.NodeID}, nil
+}
+
+// Revoke deletes a token; nodes that joined with it stay members
+func (r *Registry) Revoke(ctx context.Context, id, actor string) error {
+	r.mu.Lock()
+	i := slices.IndexFunc(r.tokens, func(t token) bool { return t.ID == id })
+	if i < 0 {
+		r.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	t := r.tokens[i]
+	r.tokens = slices.Delete(r.tokens, i, i+1)
+	err := r.saveLocked()
+	if err != nil {
+		r.tokens = slices.Insert(r.tokens, i, t)
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	r.auditChange(ctx, "revoke_join_token", t.Record, actor)
+	return nil
+}
+
+// Admit handles the handshake of a node presenting a join token, as
+// netp2p.HandshakeOptions.Join. A node the token admits is sent the
+// credentials and Admit returns netp2p.ErrJoined; otherwise the error
+// refusing it.
+func (r *Registry) Admit(peer netp2p.Peer, msg netp2p.HandshakeMessage) error {
+	remote := peer.RemoteAddr().String()
+	member := Member{NodeID: msg.NodeID, Addr: remote, Verified: len(msg.PublicKey) > 0}
+	secret, err := r.admit(member, msg)
+	if err != nil {
+		r.auditRefused(msg.JoinToken, member, err)
+		return err
+	}
+
+	creds := r.opts.Credentials()
+	creds.Issuer = r.opts.NodeID
+	sealed, err := seal(secret, msg.NodeID, r.opts.NodeID, creds)
+	if err != nil {
+		return err
+	}
+	if err := writeFrame(peer, sealed); err != nil {
+		return fmt.Errorf("join: failed to send credentials to %s: %w", remote, err)
+	}
+	r.auditAdmitted(msg.JoinToken, member)
+	return netp2p.ErrJoined
+}
+
+// admit checks the token presented in msg and records the member, returning
+// the token's secret
+func (r *Registry) admit(member Member, msg netp2p.HandshakeMessage) ([]byte, error) {
+	now := r.opts.Now()
+	if msg.NodeID == "" || msg.NodeID == r.opts.NodeID {
+		return nil, fmt.Errorf("%w: invalid node ID %q", ErrRefused, msg.NodeID)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := slices.IndexFunc(r.tokens, func(t token) bool { return t.ID == msg.JoinToken })
+	if i < 0 {
+		return nil, fmt.Errorf("%w: unknown token", ErrRefused)
+	}
+	t := r.tokens[i]
+	switch {
+	case t.Expired(now):
+		return nil, fmt.Errorf("%w: token %s expired", ErrRefused, t.ID)
+	case t.MaxUses > 0 && t.Uses >= t.MaxUses:
+		return nil, fmt.Errorf("%w: token %s used up", ErrRefused, t.ID)
+	case !netp2p.VerifyHandshakeMessage(msg, string(t.Secret)):
+		return nil, fmt.Errorf("%w: invalid signature for token %s", ErrRefused, t.ID)
+	case now.Sub(time.Unix(msg.Timestamp, 0)) > maxSkew:
+		return nil, fmt.Errorf("%w: handshake timestamp too old", ErrRefused)
+	}
+	if err := netp2p.VerifyHandshakeIdentity(msg); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRefused, err)
+	}
+	if r.opts.Check != nil {
+		if err := r.opts.Check(member.NodeID, member.Addr, member.Verified); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrRefused, err)
+		}
+	}
+
+	member.JoinedAt = now.UTC()
+	t.Uses++
+	t.Joined = append(slices.Clone(t.Joined), member)
+	r.tokens[i] = t
+	if err := r.saveLocked(); err != nil {
+		return nil, err
+	}
+	return t.Secret, nil
+}
+
+func (t token) clone() Record {
+	record := t.Record
+	record.Joined = slices.Clone(t.Joined)
+	return record
+}
+
+func (r *Registry) load() error {
+	if r.opts.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(r.opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &r.tokens); err != nil {
+		return fmt.Errorf("join: corrupt token store %s: %w", r.opts.Path, err)
+	}
+	return nil
+}
+
+func (r *Registry) saveLocked() error {
+	if r.opts.Path == "" {
+		return nil
+	}
+	tokens := r.tokens
+	if tokens == nil {
+		tokens = []token{}
+	}
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.opts.Path), 0700); err != nil {
+		return err
+	}
+	tmp := r.opts.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.opts.Path)
+}
+
+func (r *Registry) auditLogger() *audit.AuditLogger {
+	if r.opts.Audit != nil {
+		return r.opts.Audit
+	}
+	return audit.GlobalAuditLogger
+}
+
+// auditChange records a token being created or revoked
+func (r *Registry) auditChange(ctx context.Context, action string, record Record, actor string) {
+	logger := r.auditLogger()
+	if logger == nil {
+		slog.Info("join tokens changed", "action", action, "token", record.ID, "actor", actor)
+		return
+	}
+	details := map[string]interface{}{"token": record.ID, "expires_at": record.ExpiresAt, "max_uses": record.MaxUses}
+	if err := logger.LogAdminEvent(ctx, actor, action, "success", details); err != nil {
+		slog.Warn("join: failed to write audit event", "error", err)
+	}
+}
+
+// auditAdmitted records a node joining the cluster
+func (r *Registry) auditAdmitted(tokenID string, m Member) {
+	r.auditJoin(tokenID, m, audit.AuditLevelInfo, "success", fmt.Sprintf("Node %s joined the cluster from %s with token %s", m.NodeID, m.Addr, tokenID))
+}
+
+// auditRefused records a node the token did not admit
+func (r *Registry) auditRefused(tokenID string, m Member, reason error) {
+	r.auditJoin(tokenID, m, audit.AuditLevelWarning, "denied", fmt.Sprintf("Node %s from %s refused: %v", m.NodeID, m.Addr, reason))
+}
+
+func (r *Registry) auditJoin(tokenID string, m Member, level audit.AuditLevel, result, message string) {
+	logger := r.auditLogger()
+	if logger == nil {
+		slog.Info("cluster join", "node", m.NodeID, "addr", m.Addr, "token", tokenID, "result", result)
+		return
+	}
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		host = m.Addr
+	}
+	event := &audit.AuditEvent{
+		Type:      audit.AuditEventTypeSecurity,
+		Level:     level,
+		IPAddress: host,
+		Resource:  m.NodeID,
+		Action:    "join",
+		Result:    result,
+		Message:   message,
+		Details:   map[string]interface{}{"addr": m.Addr, "node_id": m.NodeID, "verified": m.Verified, "token": tokenID},
+		Source:    "cluster-join",
+		Category:  "network",
+		Tags:      []string{"cluster-join", result},
+	}
+	if err := logger.LogEvent(context.Background(), event); err != nil {
+		slog.Warn("join: failed to write audit event", "error", err)
+	}
+}
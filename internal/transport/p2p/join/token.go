@@ -0,0 +1,51 @@
+package join
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// tokenPrefix marks join tokens and their format version
+const tokenPrefix = "pvjoin1."
+
+// Token is what an operator hands to a new node: where to join and the
+// secret proving it may
+type Token struct {
+	// ID names the token on the node that issued it
+	ID string `json:"id"`
+	// Secret proves the holder may join; it never crosses the network
+	Secret []byte `json:"secret"`
+	// Addrs are the P2P addresses of the issuing node
+	Addrs []string `json:"addrs"`
+	// Issuer is the node ID of the issuing node, which the joining node
+	// checks it reached
+	Issuer string `json:"issuer"`
+}
+
+// String encodes the token for operators to copy
+func (t Token) String() string {
+	data, _ := json.Marshal(t)
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseToken decodes a token encoded by Token.String
+func ParseToken(s string) (Token, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(s), tokenPrefix)
+	if !ok {
+		return Token{}, fmt.Errorf("%w: not a join token", ErrInvalidToken)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Token{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	var t Token
+	if err := json.Unmarshal(data, &t); err != nil {
+		return Token{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if t.ID == "" || len(t.Secret) < secretSize || len(t.Addrs) == 0 {
+		return Token{}, fmt.Errorf("%w: incomplete token", ErrInvalidToken)
+	}
+	return t, nil
+}
@@ -0,0 +1,198 @@
+package p2p
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// MaxHandshakeSize is the largest handshake message a peer may send
+const MaxHandshakeSize = 4096
+
+// MaxHandshakeSkew is how many seconds the timestamp of a peer's handshake
+// may be off from this node's clock, either way
+const MaxHandshakeSkew = 30
+
+// Limits protect a transport from peers that open connections or send
+// messages faster than a node can serve them. Zero fields take the value of
+// DefaultLimits; negative ones turn the limit off.
+type Limits struct {
+	// ConnectionRate is how many connections one IP may open per second,
+	// in bursts of up to ConnectionBurst
+	ConnectionRate  float64
+	ConnectionBurst int
+	// MaxConnectionsPerIP caps the open inbound connections of one IP
+	MaxConnectionsPerIP int
+	// MaxHandshakes caps the inbound handshakes in progress; connections
+	// beyond it are closed right away
+	MaxHandshakes int
+	// HandshakeTimeout is how long a peer has to complete the handshake
+	HandshakeTimeout time.Duration
+	// MaxMessageSize is the largest message a peer may send
+	MaxMessageSize int
+	// MessageRate is how many messages a peer may send per second, in
+	// bursts of up to MessageBurst; peers sending more are dropped
+	MessageRate  float64
+	MessageBurst int
+}
+
+// DefaultLimits returns limits generous enough for a busy cluster
+func DefaultLimits() Limits {
+	return Limits{
+		ConnectionRate:      20,
+		ConnectionBurst:     50,
+		MaxConnectionsPerIP: 64,
+		MaxHandshakes:       128,
+		HandshakeTimeout:    10 * time.Second,
+		MaxMessageSize:      MaxFrameSize,
+		MessageRate:         2000,
+		MessageBurst:        4000,
+	}
+}
+
+// withDefaults fills the zero fields of l from DefaultLimits
+func (l Limits) withDefaults() Limits {
+	d := DefaultLimits()
+	if l.ConnectionRate == 0 {
+		l.ConnectionRate = d.ConnectionRate
+	}
+	if l.ConnectionBurst == 0 {
+		l.ConnectionBurst = d.ConnectionBurst
+	}
+	if l.MaxConnectionsPerIP == 0 {
+		l.MaxConnectionsPerIP = d.MaxConnectionsPerIP
+	}
+	if l.MaxHandshakes == 0 {
+		l.MaxHandshakes = d.MaxHandshakes
+	}
+	if l.HandshakeTimeout == 0 {
+		l.HandshakeTimeout = d.HandshakeTimeout
+	}
+	if l.MaxMessageSize == 0 {
+		l.MaxMessageSize = d.MaxMessageSize
+	}
+	if l.MessageRate == 0 {
+		l.MessageRate = d.MessageRate
+	}
+	if l.MessageBurst == 0 {
+		l.MessageBurst = d.MessageBurst
+	}
+	return l
+}
+
+// messageLimiter returns the limiter of the messages of one connection,
+// nil when messages are not limited
+func (l Limits) messageLimiter() *rate.Limiter {
+	if l.MessageRate < 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(l.MessageRate), max(l.MessageBurst, 1))
+}
+
+// connLimiter admits inbound connections within the limits
+type connLimiter struct {
+	limits     Limits
+	handshakes chan struct{}
+
+	mu        sync.Mutex
+	ips       map[string]*ipConns
+	lastSweep time.Time
+}
+
+// ipConns tracks the connections of one IP
+type ipConns struct {
+	rate  *rate.Limiter
+	conns int
+}
+
+// ipSweepInterval is how often IPs without connections whose rate has
+// recovered are forgotten
+const ipSweepInterval = time.Minute
+
+func newConnLimiter(limits Limits) *connLimiter {
+	l := &connLimiter{limits: limits, ips: make(map[string]*ipConns)}
+	if limits.MaxHandshakes > 0 {
+		l.handshakes = make(chan struct{}, limits.MaxHandshakes)
+	}
+	return l
+}
+
+// admit reserves a connection for the IP of addr; release must be called
+// with the same address once it is closed
+func (l *connLimiter) admit(addr net.Addr) error {
+	ip := addrIP(addr)
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > ipSweepInterval {
+		l.sweep(now)
+	}
+	c, ok := l.ips[ip]
+	if !ok {
+		c = &ipConns{}
+		if l.limits.ConnectionRate >= 0 {
+			c.rate = rate.NewLimiter(rate.Limit(l.limits.ConnectionRate), max(l.limits.ConnectionBurst, 1))
+		}
+		l.ips[ip] = c
+	}
+	if l.limits.MaxConnectionsPerIP > 0 && c.conns >= l.limits.MaxConnectionsPerIP {
+		return fmt.Errorf("%s has %d connections open", ip, c.conns)
+	}
+	if c.rate != nil && !c.rate.AllowN(now, 1) {
+		return fmt.Errorf("%s opens connections too fast", ip)
+	}
+	c.conns++
+	return nil
+}
+
+func (l *connLimiter) release(addr net.Addr) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c, ok := l.ips[addrIP(addr)]; ok && c.conns > 0 {
+		c.conns--
+	}
+}
+
+// sweep forgets the IPs that would start over with a fresh state anyway
+func (l *connLimiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for ip, c := range l.ips {
+		if c.conns == 0 && (c.rate == nil || c.rate.TokensAt(now) >= float64(c.rate.Burst())) {
+			delete(l.ips, ip)
+		}
+	}
+}
+
+// startHandshake takes a handshake slot, reporting false when all are in use
+func (l *connLimiter) startHandshake() bool {
+	if l.handshakes == nil {
+		return true
+	}
+	select {
+	case l.handshakes <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *connLimiter) endHandshake() {
+	if l.handshakes != nil {
+		<-l.handshakes
+	}
+}
+
+func addrIP(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,129 @@
+package p2p
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listen starts a transport with limits on a loopback port
+func listen(t *testing.T, limits Limits, handshake HandshakeFunc) (*TCPTransport, string) {
+	t.Helper()
+	tr := NewTCPTransport(TCPTransportOpts{ListenAddr: "127.0.0.1:0", HandshakeFunc: handshake, Limits: limits})
+	require.NoError(t, tr.ListenAndAccept())
+	t.Cleanup(func() { _ = tr.Close() })
+	return tr, tr.listener.Addr().String()
+}
+
+func dial(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// dropped reports whether the transport closed conn, skipping what it sent
+func dropped(conn net.Conn) bool {
+	_ = conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	buf := make([]byte, 512)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			return !errors.Is(err, os.ErrDeadlineExceeded)
+		}
+	}
+}
+
+// droppedCount dials addr n times at once and reports how many of the
+// connections the transport dropped, returning those it kept
+func droppedCount(t *testing.T, addr string, n int) (int, []net.Conn) {
+	t.Helper()
+	conns := make([]net.Conn, n)
+	for i := range conns {
+		conns[i] = dial(t, addr)
+	}
+	var kept []net.Conn
+	for _, conn := range conns {
+		if !dropped(conn) {
+			kept = append(kept, conn)
+		}
+	}
+	return n - len(kept), kept
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	_, addr := listen(t, Limits{HandshakeTimeout: 100 * time.Millisecond}, AuthenticatedHandshakeFunc("server"))
+
+	// A client that never answers the handshake is dropped
+	conn := dial(t, addr)
+	assert.True(t, dropped(conn))
+}
+
+func TestConnectionsPerIP(t *testing.T) {
+	_, addr := listen(t, Limits{MaxConnectionsPerIP: 2, ConnectionRate: -1}, NOPHandshakeFunc)
+
+	n, kept := droppedCount(t, addr, 3)
+	assert.Equal(t, 1, n)
+
+	// Closing a connection frees its slot
+	require.NoError(t, kept[0].Close())
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, dropped(dial(t, addr)))
+}
+
+func TestConnectionRate(t *testing.T) {
+	_, addr := listen(t, Limits{ConnectionRate: 0.01, ConnectionBurst: 2}, NOPHandshakeFunc)
+
+	n, _ := droppedCount(t, addr, 3)
+	assert.Equal(t, 1, n)
+}
+
+func TestMaxHandshakes(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	_, addr := listen(t, Limits{MaxHandshakes: 1}, func(Peer) error {
+		<-release
+		return nil
+	})
+
+	n, _ := droppedCount(t, addr, 2)
+	assert.Equal(t, 1, n)
+}
+
+func TestMessageLimits(t *testing.T) {
+	_, addr := listen(t, Limits{MaxMessageSize: 16, MessageRate: 0.01, MessageBurst: 2}, NOPHandshakeFunc)
+
+	// Oversized messages drop the peer
+	conn := dial(t, addr)
+	require.NoError(t, NewFrameWriter(conn).WriteMessage(make([]byte, 17)))
+	assert.True(t, dropped(conn))
+
+	// So does flooding
+	conn = dial(t, addr)
+	writer := NewFrameWriter(conn)
+	for range 2 {
+		require.NoError(t, writer.WriteMessage(make([]byte, 16)))
+	}
+	assert.False(t, dropped(conn))
+	require.NoError(t, writer.WriteMessage(make([]byte, 16)))
+	assert.True(t, dropped(conn))
+}
+
+func TestHandshakeSize(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	// A peer announcing a huge handshake is refused before it is read
+	go func() {
+		_ = binary.Write(client, binary.BigEndian, uint32(1<<30))
+	}()
+	_, err := ReceiveHandshake(NewTCPPeer(server, false))
+	assert.ErrorContains(t, err, "too large")
+}
@@ -0,0 +1,350 @@
+// Package mdns finds PeerVault nodes on the local network with multicast
+// DNS service discovery (RFC 6762 and 6763), so nodes of a lab or edge
+// site form a cluster without bootstrap addresses.
+//
+// Each node announces itself as an instance of the _peervault._tcp service
+// and answers queries for it. The instance carries the node ID and, when
+// one is configured, a cluster name in its TXT record; nodes only report
+// peers of their own cluster. Announcements are not trusted: a discovered
+// peer is dialed like any other and must still pass the handshake and the
+// peer ACL.
+package mdns
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// DefaultService is the DNS-SD service type nodes announce
+	DefaultService = "_peervault._tcp"
+	// DefaultInterval is how often the network is queried for nodes
+	DefaultInterval = 30 * time.Second
+)
+
+// TTLs of the records, as RFC 6762 recommends for host and other records
+const (
+	hostTTL  = 120
+	otherTTL = 4500
+)
+
+// maxPacketSize is the largest multicast DNS message
+const maxPacketSize = 9000
+
+// group is the IPv4 multicast DNS group
+var group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Peer is a node found on the local network
+type Peer struct {
+	// ID is the node ID the peer announced; the handshake proves it
+	ID string
+	// Addr is the address of the peer's P2P transport
+	Addr string
+}
+
+// Options configures discovery
+type Options struct {
+	// NodeID is the ID of this node, which it announces
+	NodeID string
+	// Port is the port of this node's P2P transport
+	Port int
+	// Cluster names the cluster of this node. Only peers announcing the
+	// same cluster are reported, so separate clusters can share a network.
+	Cluster string
+	// Service is the DNS-SD service type, DefaultService when empty
+	Service string
+	// Interval is how often the network is queried, DefaultInterval when 0
+	Interval time.Duration
+	// OnPeer is called for each peer found, every time it answers
+	OnPeer func(Peer)
+	Logger *slog.Logger
+}
+
+// Discovery announces this node and finds the other nodes on the local
+// network
+type Discovery struct {
+	opts     Options
+	service  dnsmessage.Name
+	instance dnsmessage.Name
+	host     dnsmessage.Name
+
+	conn *net.UDPConn
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New creates a discovery for the node, which Start runs
+func New(opts Options) (*Discovery, error) {
+	if opts.NodeID == "" {
+		return nil, errors.New("mdns: node ID is required")
+	}
+	if opts.Port <= 0 || opts.Port > 65535 {
+		return nil, fmt.Errorf("mdns: invalid port %d", opts.Port)
+	}
+	if opts.Service == "" {
+		opts.Service = DefaultService
+	}
+	name, proto, _ := strings.Cut(opts.Service, ".")
+	if len(name) < 2 || name[0] != '_' || (proto != "_tcp" && proto != "_udp") {
+		return nil, fmt.Errorf("mdns: invalid service %q, expected _name._tcp", opts.Service)
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	// Labels are limited to 63 bytes; the full ID is in the TXT record
+	label := "peervault-" + opts.NodeID[:min(len(opts.NodeID), 40)]
+	service, err := dnsmessage.NewName(opts.Service + ".local.")
+	if err != nil {
+		return nil, fmt.Errorf("mdns: invalid service %q: %w", opts.Service, err)
+	}
+	instance, err := dnsmessage.NewName(label + "." + service.String())
+	if err != nil {
+		return nil, fmt.Errorf("mdns: invalid service %q: %w", opts.Service, err)
+	}
+	host, err := dnsmessage.NewName(label + ".local.")
+	if err != nil {
+		return nil, fmt.Errorf("mdns: invalid node ID %q: %w", opts.NodeID, err)
+	}
+	return &Discovery{opts: opts, service: service, instance: instance, host: host, quit: make(chan struct{})}, nil
+}
+
+// Start joins the multicast group, announces this node and queries for
+// others until Stop
+func (d *Discovery) Start() error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return fmt.Errorf("mdns: %w", err)
+	}
+	d.conn = conn
+	d.wg.Add(2)
+	go d.receive()
+	go d.query()
+	d.opts.Logger.Info("Discovering peers with mDNS", "service", d.service.String(), "cluster", d.opts.Cluster)
+	return nil
+}
+
+// Stop stops announcing and querying
+func (d *Discovery) Stop() {
+	select {
+	case <-d.quit:
+		return
+	default:
+	}
+	close(d.quit)
+	if d.conn != nil {
+		d.conn.Close()
+	}
+	d.wg.Wait()
+}
+
+// query announces this node and asks for the others every interval
+func (d *Discovery) query() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.opts.Interval)
+	defer ticker.Stop()
+	for {
+		for _, msg := range [][]byte{d.response(), d.question()} {
+			if msg == nil {
+				continue
+			}
+			if _, err := d.conn.WriteToUDP(msg, group); err != nil {
+				d.opts.Logger.Debug("mDNS send failed", "error", err)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-d.quit:
+			return
+		}
+	}
+}
+
+// receive answers queries and reports the peers of responses
+func (d *Discovery) receive() {
+	defer d.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := d.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-d.quit:
+				return
+			default:
+			}
+			d.opts.Logger.Debug("mDNS receive failed", "error", err)
+			continue
+		}
+		reply := d.handle(buf[:n], from)
+		if reply == nil {
+			continue
+		}
+		if _, err := d.conn.WriteToUDP(reply, group); err != nil {
+			d.opts.Logger.Debug("mDNS send failed", "error", err)
+		}
+	}
+}
+
+// handle processes a message received from, returning the response to
+// send, if any
+func (d *Discovery) handle(packet []byte, from *net.UDPAddr) []byte {
+	var p dnsmessage.Parser
+	header, err := p.Start(packet)
+	if err != nil {
+		return nil
+	}
+	if !header.Response {
+		questions, err := p.AllQuestions()
+		if err != nil {
+			return nil
+		}
+		for _, q := range questions {
+			if (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL) && strings.EqualFold(q.Name.String(), d.service.String()) {
+				return d.response()
+			}
+		}
+		return nil
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil
+	}
+	var records []dnsmessage.Resource
+	for {
+		r, err := p.Answer()
+		if err != nil {
+			break
+		}
+		records = append(records, r)
+	}
+	_ = p.SkipAllAuthorities()
+	for {
+		r, err := p.Additional()
+		if err != nil {
+			break
+		}
+		records = append(records, r)
+	}
+	for _, peer := range d.peers(records, from) {
+		d.opts.OnPeer(peer)
+	}
+	return nil
+}
+
+// peers returns the peers of this service and cluster in the records of a
+// response from. The address is the one the response came from, which is
+// on this network whatever addresses the peer has.
+func (d *Discovery) peers(records []dnsmessage.Resource, from *net.UDPAddr) []Peer {
+	var instances []string
+	ports := make(map[string]uint16)
+	txts := make(map[string][]string)
+	for _, r := range records {
+		name := strings.ToLower(r.Header.Name.String())
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if strings.EqualFold(name, d.service.String()) {
+				instances = append(instances, strings.ToLower(body.PTR.String()))
+			}
+		case *dnsmessage.SRVResource:
+			ports[name] = body.Port
+		case *dnsmessage.TXTResource:
+			txts[name] = body.TXT
+		}
+	}
+
+	var peers []Peer
+	for _, instance := range instances {
+		port, ok := ports[instance]
+		if !ok || port == 0 {
+			continue
+		}
+		attrs := make(map[string]string)
+		for _, txt := range txts[instance] {
+			key, value, _ := strings.Cut(txt, "=")
+			attrs[key] = value
+		}
+		id := attrs["id"]
+		if id == "" || id == d.opts.NodeID || attrs["cluster"] != d.opts.Cluster {
+			continue
+		}
+		peers = append(peers, Peer{ID: id, Addr: net.JoinHostPort(from.IP.String(), fmt.Sprint(port))})
+	}
+	return peers
+}
+
+// question returns a query for the instances of the service
+func (d *Discovery) question() []byte {
+	msg := dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: d.service, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}
+	packet, err := msg.Pack()
+	if err != nil {
+		d.opts.Logger.Error("Failed to build mDNS query", "error", err)
+		return nil
+	}
+	return packet
+}
+
+// response returns the records announcing this node
+func (d *Discovery) response() []byte {
+	txt := []string{"id=" + d.opts.NodeID}
+	if d.opts.Cluster != "" {
+		txt = append(txt, "cluster="+d.opts.Cluster)
+	}
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: d.service, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: otherTTL},
+			Body:   &dnsmessage.PTRResource{PTR: d.instance},
+		}},
+		Additionals: []dnsmessage.Resource{
+			{
+				Header: dnsmessage.ResourceHeader{Name: d.instance, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET, TTL: hostTTL},
+				Body:   &dnsmessage.SRVResource{Target: d.host, Port: uint16(d.opts.Port)},
+			},
+			{
+				Header: dnsmessage.ResourceHeader{Name: d.instance, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: otherTTL},
+				Body:   &dnsmessage.TXTResource{TXT: txt},
+			},
+		},
+	}
+	for _, ip := range localIPv4() {
+		msg.Additionals = append(msg.Additionals, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: d.host, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: hostTTL},
+			Body:   &dnsmessage.AResource{A: [4]byte(ip)},
+		})
+	}
+	packet, err := msg.Pack()
+	if err != nil {
+		d.opts.Logger.Error("Failed to build mDNS response", "error", err)
+		return nil
+	}
+	return packet
+}
+
+// localIPv4 returns the IPv4 addresses of the host other than loopback,
+// for the A records of the response
+func localIPv4() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
+			if ip := ipnet.IP.To4(); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
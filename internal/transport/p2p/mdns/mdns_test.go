@@ -0,0 +1,115 @@
+package mdns
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder collects the peers a discovery reports
+type recorder struct {
+	mu    sync.Mutex
+	peers []Peer
+}
+
+func (r *recorder) add(p Peer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.peers = append(r.peers, p)
+}
+
+func (r *recorder) all() []Peer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Peer(nil), r.peers...)
+}
+
+func newDiscovery(t *testing.T, id string, port int, cluster string, r *recorder) *Discovery {
+	t.Helper()
+	d, err := New(Options{NodeID: id, Port: port, Cluster: cluster, OnPeer: r.add})
+	require.NoError(t, err)
+	return d
+}
+
+func TestNewValidates(t *testing.T) {
+	_, err := New(Options{Port: 3000})
+	assert.Error(t, err)
+	_, err = New(Options{NodeID: "a", Port: 70000})
+	assert.Error(t, err)
+	_, err = New(Options{NodeID: "a", Port: 3000, Service: "bad..service"})
+	assert.Error(t, err)
+}
+
+func TestQueryAndResponse(t *testing.T) {
+	var ra, rb recorder
+	a := newDiscovery(t, "aaaa", 3000, "lab", &ra)
+	b := newDiscovery(t, "bbbb", 4000, "lab", &rb)
+	from := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 5353}
+
+	// b answers the query of a, which reports b at the address the answer
+	// came from
+	reply := b.handle(a.question(), from)
+	require.NotNil(t, reply)
+	assert.Nil(t, a.handle(reply, from))
+	assert.Equal(t, []Peer{{ID: "bbbb", Addr: "192.168.1.20:4000"}}, ra.all())
+
+	// Nodes ignore their own announcements
+	assert.Nil(t, b.handle(reply, from))
+	assert.Empty(t, rb.all())
+}
+
+func TestOtherClustersAndServicesAreIgnored(t *testing.T) {
+	var ra, rb, rc recorder
+	a := newDiscovery(t, "aaaa", 3000, "lab", &ra)
+	b := newDiscovery(t, "bbbb", 4000, "edge", &rb)
+	c, err := New(Options{NodeID: "cccc", Port: 5000, Cluster: "lab", Service: "_other._tcp", OnPeer: rc.add})
+	require.NoError(t, err)
+	from := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5353}
+
+	a.handle(b.response(), from)
+	a.handle(c.response(), from)
+	assert.Empty(t, ra.all())
+	// Queries for another service are not answered
+	assert.Nil(t, c.handle(a.question(), from))
+	assert.Nil(t, a.handle([]byte("not a DNS message"), from))
+}
+
+func TestDiscoveryOverMulticast(t *testing.T) {
+	var ra, rb recorder
+	a := newDiscovery(t, "aaaa", 3000, "", &ra)
+	b := newDiscovery(t, "bbbb", 4000, "", &rb)
+	a.opts.Interval = 100 * time.Millisecond
+	if err := a.Start(); err != nil {
+		t.Skipf("multicast is not available: %v", err)
+	}
+	defer a.Stop()
+	require.NoError(t, b.Start())
+	defer b.Stop()
+
+	found := func(r *recorder, id string) bool {
+		for _, p := range r.all() {
+			if p.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+	// Some hosts, such as containers, drop multicast
+	for deadline := time.Now().Add(3 * time.Second); !found(&ra, "bbbb") || !found(&rb, "aaaa"); time.Sleep(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Skip("multicast packets do not reach this host")
+		}
+	}
+	assert.Equal(t, "4000", portOf(t, ra.all()[0].Addr))
+}
+
+func portOf(t *testing.T, addr string) string {
+	t.Helper()
+	_, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	return port
+}
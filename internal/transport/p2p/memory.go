@@ -0,0 +1,331 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// errRefused is returned for dials to addresses nothing listens on
+var errRefused = errors.New("connection refused")
+
+// MemoryNetwork connects transports in memory, without sockets, for tests
+// running whole clusters in one process. Addresses are host:port strings
+// only meaningful within the network. The connections a host dials come
+// from ports of its own, so peers see them as they would over TCP.
+//
+// Hosts can be partitioned from each other: dials across a partition are
+// refused and the connections crossing it are closed.
+type MemoryNetwork struct {
+	mu        sync.Mutex
+	listeners map[string]*memoryListener
+	conns     map[*memoryConn]struct{}
+	groups    map[string]int // partition group of each host, when partitioned
+	nextPort  int
+}
+
+// NewMemoryNetwork creates an empty network
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		listeners: make(map[string]*memoryListener),
+		conns:     make(map[*memoryConn]struct{}),
+		nextPort:  49152,
+	}
+}
+
+// Host returns the network as seen from host, for the transport of the
+// node running there
+func (n *MemoryNetwork) Host(host string) Network {
+	return memoryHost{network: n, host: host}
+}
+
+// Partition splits the hosts into groups that cannot reach each other,
+// closing the connections between them. Hosts left out of every group can
+// still reach all others.
+func (n *MemoryNetwork) Partition(groups ...[]string) {
+	n.mu.Lock()
+	n.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, host := range group {
+			n.groups[host] = i
+		}
+	}
+	var cut []*memoryConn
+	for c := range n.conns {
+		if !n.reachableLocked(c.local.host(), c.remote.host()) {
+			cut = append(cut, c)
+		}
+	}
+	n.mu.Unlock()
+	for _, c := range cut {
+		c.sever()
+	}
+}
+
+// Heal lifts the partitions; hosts must dial each other again
+func (n *MemoryNetwork) Heal() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.groups = nil
+}
+
+// Disconnect closes every connection of host and returns how many there
+// were, as when the machine running it crashes
+func (n *MemoryNetwork) Disconnect(host string) int {
+	n.mu.Lock()
+	var closed []*memoryConn
+	for c := range n.conns {
+		if c.local.host() == host {
+			closed = append(closed, c)
+		}
+	}
+	n.mu.Unlock()
+	for _, c := range closed {
+		_ = c.Close()
+	}
+	return len(closed)
+}
+
+// reachableLocked reports whether a partition separates the hosts
+func (n *MemoryNetwork) reachableLocked(a, b string) bool {
+	ga, okA := n.groups[a]
+	gb, okB := n.groups[b]
+	return !okA || !okB || ga == gb
+}
+
+func (n *MemoryNetwork) listen(addr string) (net.Listener, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.listeners[addr]; ok {
+		return nil, fmt.Errorf("listen %s: address already in use", addr)
+	}
+	l := &memoryListener{
+		network: n,
+		addr:    memoryAddr(addr),
+		accept:  make(chan net.Conn, 128),
+		closed:  make(chan struct{}),
+	}
+	n.listeners[addr] = l
+	return l, nil
+}
+
+func (n *MemoryNetwork) dial(from, addr string) (net.Conn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	l, ok := n.listeners[addr]
+	if !ok {
+		return nil, &net.OpError{Op: "dial", Net: "memory", Addr: memoryAddr(addr), Err: errRefused}
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	if !n.reachableLocked(from, host) {
+		return nil, &net.OpError{Op: "dial", Net: "memory", Addr: memoryAddr(addr), Err: os.ErrDeadlineExceeded}
+	}
+	local := memoryAddr(net.JoinHostPort(from, strconv.Itoa(n.nextPort)))
+	n.nextPort++
+
+	toServer, toClient := newMemoryBuffer(), newMemoryBuffer()
+	client := &memoryConn{network: n, local: local, remote: l.addr, in: toClient, out: toServer}
+	server := &memoryConn{network: n, local: l.addr, remote: local, in: toServer, out: toClient}
+	select {
+	case l.accept <- server:
+	default:
+		return nil, &net.OpError{Op: "dial", Net: "memory", Addr: l.addr, Err: errRefused}
+	}
+	n.conns[client] = struct{}{}
+	n.conns[server] = struct{}{}
+	return client, nil
+}
+
+func (n *MemoryNetwork) forget(c *memoryConn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.conns, c)
+}
+
+// memoryHost is the network as seen from one host
+type memoryHost struct {
+	network *MemoryNetwork
+	host    string
+}
+
+func (h memoryHost) Listen(addr string) (net.Listener, error) { return h.network.listen(addr) }
+
+func (h memoryHost) DialTimeout(addr string, _ time.Duration) (net.Conn, error) {
+	return h.network.dial(h.host, addr)
+}
+
+// memoryAddr is a host:port address of a MemoryNetwork
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return "memory" }
+func (a memoryAddr) String() string  { return string(a) }
+
+func (a memoryAddr) host() string {
+	host, _, _ := net.SplitHostPort(string(a))
+	return host
+}
+
+// memoryListener accepts the connections dialed to its address
+type memoryListener struct {
+	network *MemoryNetwork
+	addr    memoryAddr
+	accept  chan net.Conn
+	once    sync.Once
+	closed  chan struct{}
+}
+
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memoryListener) Close() error {
+	l.once.Do(func() {
+		l.network.mu.Lock()
+		delete(l.network.listeners, string(l.addr))
+		l.network.mu.Unlock()
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr { return l.addr }
+
+// memoryConn is one end of a connection. Writes never block: they queue
+// in the buffer the other end reads from, like the socket buffers of TCP.
+type memoryConn struct {
+	network       *MemoryNetwork
+	local, remote memoryAddr
+	in, out       *memoryBuffer
+	once          sync.Once
+}
+
+func (c *memoryConn) Read(p []byte) (int, error)  { return c.in.read(p) }
+func (c *memoryConn) Write(p []byte) (int, error) { return c.out.write(p) }
+
+// Close ends the connection: the other end reads what was written before,
+// then io.EOF
+func (c *memoryConn) Close() error {
+	c.once.Do(func() {
+		c.in.close(net.ErrClosed)
+		c.out.close(io.EOF)
+		c.network.forget(c)
+	})
+	return nil
+}
+
+// sever ends the connection as the network does: neither end closed it, so
+// both read what was written before, then io.EOF
+func (c *memoryConn) sever() {
+	c.once.Do(func() {
+		c.in.close(io.EOF)
+		c.out.close(io.EOF)
+		c.network.forget(c)
+	})
+}
+
+func (c *memoryConn) LocalAddr() net.Addr  { return c.local }
+func (c *memoryConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *memoryConn) SetDeadline(t time.Time) error { return c.SetReadDeadline(t) }
+
+func (c *memoryConn) SetReadDeadline(t time.Time) error {
+	c.in.setDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline does nothing, as writes never block
+func (c *memoryConn) SetWriteDeadline(time.Time) error { return nil }
+
+// memoryBuffer holds the bytes written to one end of a connection until
+// the other end reads them
+type memoryBuffer struct {
+	mu       sync.Mutex
+	data     []byte
+	err      error         // returned once data is drained, after a close
+	deadline time.Time     // of reads
+	changed  chan struct{} // closed when data, err or deadline change
+}
+
+func newMemoryBuffer() *memoryBuffer {
+	return &memoryBuffer{changed: make(chan struct{})}
+}
+
+// signalLocked wakes the reader waiting on the buffer
+func (b *memoryBuffer) signalLocked() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *memoryBuffer) write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return 0, net.ErrClosed
+	}
+	b.data = append(b.data, p...)
+	b.signalLocked()
+	return len(p), nil
+}
+
+func (b *memoryBuffer) read(p []byte) (int, error) {
+	for {
+		b.mu.Lock()
+		if len(b.data) > 0 {
+			n := copy(p, b.data)
+			b.data = b.data[n:]
+			b.mu.Unlock()
+			return n, nil
+		}
+		if b.err != nil {
+			err := b.err
+			b.mu.Unlock()
+			return 0, err
+		}
+		changed, deadline := b.changed, b.deadline
+		b.mu.Unlock()
+
+		if deadline.IsZero() {
+			<-changed
+			continue
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (b *memoryBuffer) close(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		b.err = err
+		b.signalLocked()
+	}
+}
+
+func (b *memoryBuffer) setDeadline(t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deadline = t
+	b.signalLocked()
+}
@@ -0,0 +1,80 @@
+package p2p
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryNetworkConnects(t *testing.T) {
+	n := NewMemoryNetwork()
+	l, err := n.Host("a").Listen("a:1")
+	require.NoError(t, err)
+	defer l.Close()
+	_, err = n.Host("a").Listen("a:1")
+	assert.Error(t, err, "the address is taken")
+
+	client, err := n.Host("b").DialTimeout("a:1", time.Second)
+	require.NoError(t, err)
+	server, err := l.Accept()
+	require.NoError(t, err)
+	assert.Equal(t, "a:1", client.RemoteAddr().String())
+	assert.Equal(t, client.LocalAddr().String(), server.RemoteAddr().String())
+	assert.Equal(t, "b", addrIP(server.RemoteAddr()))
+
+	_, err = client.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, client.Close())
+	data, err := io.ReadAll(server)
+	require.NoError(t, err, "the other end reads what was written, then EOF")
+	assert.Equal(t, "hello", string(data))
+	_, err = server.Write([]byte("late"))
+	assert.Error(t, err)
+
+	_, err = n.Host("b").DialTimeout("a:2", time.Second)
+	assert.Error(t, err, "nothing listens there")
+}
+
+func TestMemoryNetworkDeadline(t *testing.T) {
+	n := NewMemoryNetwork()
+	l, err := n.Host("a").Listen("a:1")
+	require.NoError(t, err)
+	defer l.Close()
+	client, err := n.Host("b").DialTimeout("a:1", time.Second)
+	require.NoError(t, err)
+	defer client.Close()
+
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(20*time.Millisecond)))
+	_, err = client.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestMemoryNetworkPartition(t *testing.T) {
+	n := NewMemoryNetwork()
+	l, err := n.Host("a").Listen("a:1")
+	require.NoError(t, err)
+	defer l.Close()
+	client, err := n.Host("b").DialTimeout("a:1", time.Second)
+	require.NoError(t, err)
+	server, err := l.Accept()
+	require.NoError(t, err)
+
+	n.Partition([]string{"a"}, []string{"b"})
+	_, err = server.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF, "connections across the partition are closed")
+	_, err = client.Write([]byte("x"))
+	assert.Error(t, err)
+	_, err = n.Host("b").DialTimeout("a:1", time.Second)
+	assert.Error(t, err)
+	_, err = n.Host("c").DialTimeout("a:1", time.Second)
+	assert.NoError(t, err, "hosts outside the groups reach every other")
+
+	n.Heal()
+	_, err = n.Host("b").DialTimeout("a:1", time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n.Disconnect("a"))
+}
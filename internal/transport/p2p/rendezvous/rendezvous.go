@@ -0,0 +1,293 @@
+// Package rendezvous is a lightweight service nodes register with to find
+// each other, for clusters whose members change address or cannot list
+// each other in the configuration.
+//
+// A node posts its ID and address to /v1/peers and gets back the other
+// nodes of its cluster in the same request. Registrations expire unless
+// renewed, so nodes that stop registering drop out of the list. The
+// service only keeps registrations in memory and is not trusted: nodes
+// dial the peers it lists like any other, through the handshake and the
+// peer ACL.
+package rendezvous
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/seeds"
+)
+
+const (
+	// DefaultTTL is how long a registration lasts unless renewed
+	DefaultTTL = 3 * time.Minute
+	// DefaultMaxPeers bounds the registrations of each cluster
+	DefaultMaxPeers = 1000
+)
+
+// PeersPath is where nodes register and list peers
+const PeersPath = "/v1/peers"
+
+// ErrClusterFull is returned for registrations over MaxPeers
+var ErrClusterFull = errors.New("rendezvous: cluster is full")
+
+// Registration is a node registered with the service
+type Registration struct {
+	ID        string    `json:"id"`
+	Addr      string    `json:"addr"`
+	Cluster   string    `json:"cluster,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ServerOptions configures the service
+type ServerOptions struct {
+	// Token, when set, must be sent by nodes as a bearer token
+	Token string
+	// TTL is how long registrations last, DefaultTTL when 0
+	TTL time.Duration
+	// MaxPeers bounds the registrations of each cluster, DefaultMaxPeers
+	// when 0
+	MaxPeers int
+	Logger   *slog.Logger
+}
+
+// Server keeps the registrations of every cluster
+type Server struct {
+	opts ServerOptions
+	mu   sync.Mutex
+	// clusters are the registrations by cluster and node ID
+	clusters map[string]map[string]Registration
+	now      func() time.Time
+}
+
+// NewServer creates a rendezvous service
+func NewServer(opts ServerOptions) *Server {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.MaxPeers <= 0 {
+		opts.MaxPeers = DefaultMaxPeers
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return &Server{opts: opts, clusters: make(map[string]map[string]Registration), now: time.Now}
+}
+
+// Register records a node, replacing its earlier registration, and returns
+// the other nodes of its cluster
+func (s *Server) Register(reg Registration) ([]Registration, error) {
+	if reg.ID == "" {
+		return nil, errors.New("rendezvous: node ID is required")
+	}
+	if _, err := seeds.ParsePeer(reg.Addr); err != nil {
+		return nil, fmt.Errorf("rendezvous: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.expire(now)
+	peers := s.clusters[reg.Cluster]
+	if peers == nil {
+		peers = make(map[string]Registration)
+		s.clusters[reg.Cluster] = peers
+	}
+	if _, ok := peers[reg.ID]; !ok && len(peers) >= s.opts.MaxPeers {
+		return nil, ErrClusterFull
+	}
+	reg.ExpiresAt = now.Add(s.opts.TTL)
+	peers[reg.ID] = reg
+	return s.list(reg.Cluster, reg.ID), nil
+}
+
+// Unregister removes a node
+func (s *Server) Unregister(cluster, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clusters[cluster], id)
+	if len(s.clusters[cluster]) == 0 {
+		delete(s.clusters, cluster)
+	}
+}
+
+// Peers returns the nodes of a cluster
+func (s *Server) Peers(cluster string) []Registration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(s.now())
+	return s.list(cluster, "")
+}
+
+// list returns the registrations of a cluster but the node except, sorted
+// by ID
+func (s *Server) list(cluster, except string) []Registration {
+	regs := make([]Registration, 0, len(s.clusters[cluster]))
+	for id, reg := range s.clusters[cluster] {
+		if id != except {
+			regs = append(regs, reg)
+		}
+	}
+	slices.SortFunc(regs, func(a, b Registration) int { return strings.Compare(a.ID, b.ID) })
+	return regs
+}
+
+// expire drops the registrations that were not renewed
+func (s *Server) expire(now time.Time) {
+	for cluster, peers := range s.clusters {
+		for id, reg := range peers {
+			if !now.Before(reg.ExpiresAt) {
+				delete(peers, id)
+			}
+		}
+		if len(peers) == 0 {
+			delete(s.clusters, cluster)
+		}
+	}
+}
+
+// ServeHTTP answers POST /v1/peers, which registers a node and lists the
+// others, GET /v1/peers?cluster=, which lists a cluster, and DELETE
+// /v1/peers/{id}?cluster=, which unregisters a node
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Token != "" {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.Token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	cluster := r.URL.Query().Get("cluster")
+	switch {
+	case r.URL.Path == PeersPath && r.Method == http.MethodPost:
+		var reg Registration
+		if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&reg); err != nil {
+			http.Error(w, "invalid registration: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		reg.Addr = advertisedAddr(reg.Addr, r.RemoteAddr)
+		peers, err := s.Register(reg)
+		switch {
+		case errors.Is(err, ErrClusterFull):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.opts.Logger.Debug("Node registered", "node", reg.ID, "addr", reg.Addr, "cluster", reg.Cluster)
+		writeJSON(w, peers)
+	case r.URL.Path == PeersPath && r.Method == http.MethodGet:
+		writeJSON(w, s.Peers(cluster))
+	case strings.HasPrefix(r.URL.Path, PeersPath+"/") && r.Method == http.MethodDelete:
+		s.Unregister(cluster, strings.TrimPrefix(r.URL.Path, PeersPath+"/"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// advertisedAddr fills in the host of addr, when it is missing or
+// unspecified, with the host the request came from
+func advertisedAddr(addr, remoteAddr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return addr
+	}
+	remoteHost, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return addr
+	}
+	return net.JoinHostPort(remoteHost, port)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// Client registers a node with a rendezvous service. It is a seeds.Source:
+// each listing renews the registration.
+type Client struct {
+	// URL is the base URL of the service
+	URL   string
+	Token string
+	// NodeID, Addr and Cluster are registered; an Addr without a host,
+	// such as :3000, is completed by the service with the host the node
+	// connects from
+	NodeID  string
+	Addr    string
+	Cluster string
+	// HTTP is http.DefaultClient when nil
+	HTTP *http.Client
+}
+
+// Peers registers the node and returns the other nodes of its cluster
+func (c *Client) Peers(ctx context.Context) ([]seeds.Peer, error) {
+	body, err := json.Marshal(Registration{ID: c.NodeID, Addr: c.Addr, Cluster: c.Cluster})
+	if err != nil {
+		return nil, err
+	}
+	var regs []Registration
+	if err := c.do(ctx, http.MethodPost, PeersPath, bytes.NewReader(body), &regs); err != nil {
+		return nil, err
+	}
+	peers := make([]seeds.Peer, 0, len(regs))
+	for _, reg := range regs {
+		peers = append(peers, seeds.Peer{ID: reg.ID, Addr: reg.Addr})
+	}
+	return peers, nil
+}
+
+// Unregister removes the node from the service, as when it shuts down
+func (c *Client) Unregister(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, PeersPath+"/"+url.PathEscape(c.NodeID), nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader, out any) error {
+	u := strings.TrimSuffix(c.URL, "/") + path
+	if c.Cluster != "" {
+		u += "?" + url.Values{"cluster": {c.Cluster}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("rendezvous: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("rendezvous: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,82 @@
+package rendezvous
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/seeds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterAndExpire(t *testing.T) {
+	s := NewServer(ServerOptions{TTL: time.Minute, MaxPeers: 2})
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	peers, err := s.Register(Registration{ID: "a", Addr: "10.0.0.1:3000", Cluster: "lab"})
+	require.NoError(t, err)
+	assert.Empty(t, peers)
+	peers, err = s.Register(Registration{ID: "b", Addr: "10.0.0.2:3000", Cluster: "lab"})
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+	assert.Equal(t, "a", peers[0].ID)
+
+	// Clusters are separate, and bounded
+	_, err = s.Register(Registration{ID: "c", Addr: "10.0.0.3:3000", Cluster: "lab"})
+	assert.ErrorIs(t, err, ErrClusterFull)
+	_, err = s.Register(Registration{ID: "c", Addr: "10.0.0.3:3000", Cluster: "edge"})
+	require.NoError(t, err)
+	assert.Len(t, s.Peers("lab"), 2)
+
+	// Registrations that are not renewed expire
+	now = now.Add(40 * time.Second)
+	_, err = s.Register(Registration{ID: "a", Addr: "10.0.0.1:3000", Cluster: "lab"})
+	require.NoError(t, err)
+	now = now.Add(30 * time.Second)
+	peers = s.Peers("lab")
+	require.Len(t, peers, 1)
+	assert.Equal(t, "a", peers[0].ID)
+	assert.Empty(t, s.Peers("edge"))
+
+	_, err = s.Register(Registration{ID: "", Addr: "10.0.0.1:3000"})
+	assert.Error(t, err)
+	_, err = s.Register(Registration{ID: "d", Addr: "10.0.0.1"})
+	assert.Error(t, err)
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(NewServer(ServerOptions{Token: "secret"}))
+	defer server.Close()
+
+	a := &Client{URL: server.URL, Token: "secret", NodeID: "a", Addr: ":3000", Cluster: "lab"}
+	b := &Client{URL: server.URL + "/", Token: "secret", NodeID: "b", Addr: "vault-b:3000", Cluster: "lab"}
+	ctx := context.Background()
+
+	peers, err := a.Peers(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, peers)
+	// a registered without a host, which the service took from the request
+	peers, err = b.Peers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []seeds.Peer{{ID: "a", Addr: "127.0.0.1:3000"}}, peers)
+	peers, err = a.Peers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []seeds.Peer{{ID: "b", Addr: "vault-b:3000"}}, peers)
+
+	require.NoError(t, b.Unregister(ctx))
+	peers, err = a.Peers(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, peers)
+
+	// The token is required
+	_, err = (&Client{URL: server.URL, NodeID: "c", Addr: ":3000"}).Peers(ctx)
+	assert.ErrorContains(t, err, "401")
+	resp, err := http.Get(server.URL + PeersPath)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
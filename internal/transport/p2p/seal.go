@@ -0,0 +1,128 @@
+package p2p
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Skpow1234/Peervault/internal/crypto"
+)
+
+// Links on ProtocolVersion4 with link keys seal every message: it is
+// encrypted with AES-256-CTR under the stream encryption key and a random
+// IV, and authenticated with HMAC-SHA256 under the control MAC key. The MAC
+// also covers which end of the link sent the message and how many messages
+// that end sent before it, so messages replayed, reordered, dropped or
+// reflected back to their sender fail to open.
+//
+// A sealed envelope is [version:u8][codec:u8][iv:16][ciphertext][mac:32].
+const (
+	sealIVSize  = aes.BlockSize
+	sealMACSize = sha256.Size
+	sealLabel   = crypto.SubKeyLabelPrefix + "sealed-message"
+)
+
+// ErrUnsealed is wrapped by the errors of messages that fail to open on a
+// sealed link, and of unsealed messages where sealed ones are expected
+var ErrUnsealed = errors.New("message not sealed for this link")
+
+// sealer is implemented by peers whose link seals its messages
+type sealer interface {
+	sealed() bool
+	writeSealed(payload []byte) error
+}
+
+// Sealed reports whether messages written to peer are sealed with the keys
+// of its link. Raw streams cannot be sealed, so they are refused on such
+// links.
+func Sealed(peer io.Writer) bool {
+	s, ok := peer.(sealer)
+	return ok && s.sealed()
+}
+
+func (p *TCPPeer) sealed() bool {
+	p.keyMu.RLock()
+	defer p.keyMu.RUnlock()
+	return p.version >= ProtocolVersion4 && len(p.linkKeys.StreamEncryption) > 0 && len(p.linkKeys.ControlMAC) > 0
+}
+
+// writeSealed writes payload sealed in an envelope of ProtocolVersion4.
+// Messages are numbered in the order they go out, so the number is taken
+// and the frame written under one lock.
+func (p *TCPPeer) writeSealed(payload []byte) error {
+	keys, codecID := p.LinkKeys(), p.Codec()
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	envelope, err := seal(keys, p.outbound, p.sendSeq, ProtocolVersion4, codecID, payload)
+	if err != nil {
+		return err
+	}
+	p.sendSeq++
+	return NewFrameWriter(p.Conn).writeFrame(IncomingEnvelope, envelope)
+}
+
+// open replaces the sealed payload of a message read from the peer with
+// the message it holds. Only the read loop of the connection calls it.
+func (p *TCPPeer) open(rpc *RPC) error {
+	if rpc.Stream || rpc.Version < ProtocolVersion4 {
+		return fmt.Errorf("%w: unsealed message on a sealed link", ErrUnsealed)
+	}
+	if len(rpc.Payload) < sealIVSize+sealMACSize {
+		return fmt.Errorf("%w: sealed message too short", ErrUnsealed)
+	}
+	keys := p.LinkKeys()
+	body, mac := rpc.Payload[:len(rpc.Payload)-sealMACSize], rpc.Payload[len(rpc.Payload)-sealMACSize:]
+	envelope := append([]byte{rpc.Version, rpc.Codec}, body...)
+	// The message was sent from the other end of the link
+	if !hmac.Equal(mac, sealMAC(keys.ControlMAC, !p.outbound, p.recvSeq, envelope)) {
+		return fmt.Errorf("%w: message %d fails authentication", ErrUnsealed, p.recvSeq)
+	}
+	p.recvSeq++
+
+	block, err := aes.NewCipher(keys.StreamEncryption)
+	if err != nil {
+		return err
+	}
+	payload := make([]byte, len(body)-sealIVSize)
+	cipher.NewCTR(block, body[:sealIVSize]).XORKeyStream(payload, body[sealIVSize:])
+	rpc.Payload = payload
+	return nil
+}
+
+// seal returns the envelope of payload, the seq-th message sent from the
+// outbound or inbound end of the link the keys belong to
+func seal(keys crypto.LinkKeys, outbound bool, seq uint64, version, codecID uint8, payload []byte) ([]byte, error) {
+	block, err := aes.NewCipher(keys.StreamEncryption)
+	if err != nil {
+		return nil, err
+	}
+	envelope := make([]byte, 2+sealIVSize+len(payload), 2+sealIVSize+len(payload)+sealMACSize)
+	envelope[0], envelope[1] = version, codecID
+	iv := envelope[2 : 2+sealIVSize]
+	if _, err := rand.Read(iv); err != nil {
+		return nil, fmt.Errorf("failed to generate IV: %w", err)
+	}
+	cipher.NewCTR(block, iv).XORKeyStream(envelope[2+sealIVSize:], payload)
+	return append(envelope, sealMAC(keys.ControlMAC, outbound, seq, envelope)...), nil
+}
+
+// sealMAC authenticates the envelope of the seq-th message sent from the
+// outbound or inbound end of a link
+func sealMAC(key []byte, outbound bool, seq uint64, envelope []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(sealLabel))
+	direction := byte(0)
+	if outbound {
+		direction = 1
+	}
+	mac.Write([]byte{direction})
+	mac.Write(binary.BigEndian.AppendUint64(nil, seq))
+	mac.Write(envelope)
+	return mac.Sum(nil)
+}
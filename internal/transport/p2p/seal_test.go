@@ -0,0 +1,141 @@
+package p2p
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/codec"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sealedPair connects a dialing and an accepting peer over a memory network
+// with link keys set as the handshake sets them
+func sealedPair(t *testing.T) (dialer, acceptor *TCPPeer) {
+	t.Helper()
+	n := NewMemoryNetwork()
+	l, err := n.Host("a").Listen("a:1")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	client, err := n.Host("b").DialTimeout("a:1", time.Second)
+	require.NoError(t, err)
+	server, err := l.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close(); server.Close() })
+
+	dialer, acceptor = NewTCPPeer(client, true), NewTCPPeer(server, false)
+	for _, p := range []*TCPPeer{dialer, acceptor} {
+		keys, err := crypto.DeriveLinkKeys([]byte("token"), "node-a", "node-b")
+		require.NoError(t, err)
+		p.SetLinkKeys(keys)
+		p.SetProtocolVersion(ProtocolVersion4)
+		p.SetCodec(codec.IDCBOR)
+	}
+	return dialer, acceptor
+}
+
+func readRPC(t *testing.T, conn net.Conn) RPC {
+	t.Helper()
+	var rpc RPC
+	require.NoError(t, LengthPrefixedDecoder{}.Decode(conn, &rpc))
+	return rpc
+}
+
+func TestSealedMessagesRoundTrip(t *testing.T) {
+	dialer, acceptor := sealedPair(t)
+	assert.True(t, Sealed(dialer))
+	messages := [][]byte{[]byte("store chunk 1 of report.txt"), []byte("store chunk 2 of report.txt")}
+	for _, msg := range messages {
+		require.NoError(t, NewFrameWriter(dialer).WriteMessage(msg))
+	}
+
+	for _, msg := range messages {
+		rpc := readRPC(t, acceptor.Conn)
+		assert.Equal(t, ProtocolVersion4, rpc.Version)
+		assert.Equal(t, codec.IDCBOR, rpc.Codec)
+		assert.False(t, bytes.Contains(rpc.Payload, msg), "the message does not cross the link in clear")
+		require.NoError(t, openMessage(acceptor, &rpc))
+		assert.Equal(t, msg, rpc.Payload)
+	}
+
+	// And the other way
+	require.NoError(t, NewFrameWriter(acceptor).WriteMessage([]byte("ack")))
+	rpc := readRPC(t, dialer.Conn)
+	require.NoError(t, openMessage(dialer, &rpc))
+	assert.Equal(t, []byte("ack"), rpc.Payload)
+}
+
+func TestSealedMessagesRejected(t *testing.T) {
+	t.Run("tampered", func(t *testing.T) {
+		dialer, acceptor := sealedPair(t)
+		require.NoError(t, NewFrameWriter(dialer).WriteMessage([]byte("delete report.txt")))
+		rpc := readRPC(t, acceptor.Conn)
+		rpc.Payload[sealIVSize] ^= 1
+		assert.ErrorIs(t, openMessage(acceptor, &rpc), ErrUnsealed)
+	})
+
+	t.Run("codec changed", func(t *testing.T) {
+		dialer, acceptor := sealedPair(t)
+		require.NoError(t, NewFrameWriter(dialer).WriteMessage([]byte("delete report.txt")))
+		rpc := readRPC(t, acceptor.Conn)
+		rpc.Codec = codec.IDGob
+		assert.ErrorIs(t, openMessage(acceptor, &rpc), ErrUnsealed)
+	})
+
+	t.Run("replayed", func(t *testing.T) {
+		dialer, acceptor := sealedPair(t)
+		require.NoError(t, NewFrameWriter(dialer).WriteMessage([]byte("delete report.txt")))
+		rpc := readRPC(t, acceptor.Conn)
+		replay := rpc
+		replay.Payload = bytes.Clone(rpc.Payload)
+		require.NoError(t, openMessage(acceptor, &rpc))
+		assert.ErrorIs(t, openMessage(acceptor, &replay), ErrUnsealed)
+	})
+
+	t.Run("reordered", func(t *testing.T) {
+		dialer, acceptor := sealedPair(t)
+		require.NoError(t, NewFrameWriter(dialer).WriteMessage([]byte("first")))
+		require.NoError(t, NewFrameWriter(dialer).WriteMessage([]byte("second")))
+		readRPC(t, acceptor.Conn)
+		second := readRPC(t, acceptor.Conn)
+		assert.ErrorIs(t, openMessage(acceptor, &second), ErrUnsealed)
+	})
+
+	t.Run("reflected", func(t *testing.T) {
+		dialer, acceptor := sealedPair(t)
+		require.NoError(t, NewFrameWriter(dialer).WriteMessage([]byte("delete report.txt")))
+		rpc := readRPC(t, acceptor.Conn)
+		assert.ErrorIs(t, openMessage(dialer, &rpc), ErrUnsealed, "a message is not accepted by its sender")
+	})
+
+	t.Run("unsealed", func(t *testing.T) {
+		dialer, acceptor := sealedPair(t)
+		require.NoError(t, NewFrameWriter(dialer.Conn).WriteEnvelope(ProtocolVersion3, codec.IDCBOR, []byte("delete report.txt")))
+		require.NoError(t, NewFrameWriter(dialer.Conn).WriteStreamHeader())
+		rpc := readRPC(t, acceptor.Conn)
+		assert.ErrorIs(t, openMessage(acceptor, &rpc), ErrUnsealed)
+		rpc = readRPC(t, acceptor.Conn)
+		assert.ErrorIs(t, openMessage(acceptor, &rpc), ErrUnsealed, "raw streams cannot be sealed")
+	})
+}
+
+// TestUnkeyedLinksDoNotSeal checks links negotiating ProtocolVersion4
+// without keys send version 3 envelopes, and refuse sealed ones
+func TestUnkeyedLinksDoNotSeal(t *testing.T) {
+	dialer, acceptor := sealedPair(t)
+	plain := NewTCPPeer(dialer.Conn, true)
+	plain.SetProtocolVersion(ProtocolVersion4)
+	assert.False(t, Sealed(plain))
+
+	require.NoError(t, NewFrameWriter(plain).WriteMessage([]byte("hello")))
+	rpc := readRPC(t, acceptor.Conn)
+	assert.Equal(t, ProtocolVersion3, rpc.Version)
+	assert.Equal(t, []byte("hello"), rpc.Payload)
+
+	require.NoError(t, NewFrameWriter(acceptor).WriteMessage([]byte("hello")))
+	rpc = readRPC(t, plain.Conn)
+	assert.ErrorIs(t, openMessage(plain, &rpc), ErrUnsealed)
+}
@@ -0,0 +1,131 @@
+// Package seeds lists the peers a node connects to on start, from DNS
+// records or a rendezvous service, as alternatives to a fixed list of
+// bootstrap nodes in the configuration.
+package seeds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Peer is a node to connect to
+type Peer struct {
+	// ID is the node ID of the peer, empty when the source does not know
+	// it
+	ID string `json:"id,omitempty"`
+	// Addr is the address of the peer's P2P transport
+	Addr string `json:"addr"`
+}
+
+// Source lists peers to connect to
+type Source interface {
+	Peers(ctx context.Context) ([]Peer, error)
+}
+
+// Resolver looks up DNS records; *net.Resolver is one
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// DNS lists the peers published in DNS. Each name is looked up by its
+// form:
+//
+//   - _service._proto.domain, such as _peervault._tcp.example.com, lists
+//     the targets and ports of its SRV records
+//   - host:port lists every address of host, at port
+//   - any other domain lists the entries of its TXT records, host:port or
+//     id@host:port, separated by spaces or commas
+type DNS struct {
+	Names []string
+	// Resolver is net.DefaultResolver when nil
+	Resolver Resolver
+}
+
+// Peers looks up every name. It fails only when no name could be looked
+// up.
+func (d *DNS) Peers(ctx context.Context) ([]Peer, error) {
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	var peers []Peer
+	var errs []error
+	for _, name := range d.Names {
+		found, err := lookup(ctx, resolver, strings.TrimSpace(name))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("seed %s: %w", name, err))
+			continue
+		}
+		peers = append(peers, found...)
+	}
+	if len(errs) > 0 && len(errs) == len(d.Names) {
+		return nil, errors.Join(errs...)
+	}
+	return peers, nil
+}
+
+// lookup lists the peers of one name
+func lookup(ctx context.Context, resolver Resolver, name string) ([]Peer, error) {
+	if strings.HasPrefix(name, "_") {
+		_, records, err := resolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+		peers := make([]Peer, 0, len(records))
+		for _, r := range records {
+			peers = append(peers, Peer{Addr: net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port)))})
+		}
+		return peers, nil
+	}
+
+	if host, port, err := net.SplitHostPort(name); err == nil {
+		addrs, err := resolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		peers := make([]Peer, 0, len(addrs))
+		for _, addr := range addrs {
+			peers = append(peers, Peer{Addr: net.JoinHostPort(addr, port)})
+		}
+		return peers, nil
+	}
+
+	records, err := resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	var peers []Peer
+	for _, record := range records {
+		for _, entry := range strings.FieldsFunc(record, func(r rune) bool { return r == ',' || r == ' ' }) {
+			peer, err := ParsePeer(entry)
+			if err != nil {
+				return nil, err
+			}
+			peers = append(peers, peer)
+		}
+	}
+	return peers, nil
+}
+
+// ParsePeer parses a peer written host:port or id@host:port
+func ParsePeer(s string) (Peer, error) {
+	var p Peer
+	if id, addr, ok := strings.Cut(s, "@"); ok {
+		p.ID, s = id, addr
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil || host == "" {
+		return Peer{}, fmt.Errorf("invalid peer %q, expected host:port or id@host:port", s)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return Peer{}, fmt.Errorf("invalid port in peer %q", s)
+	}
+	p.Addr = s
+	return p, nil
+}
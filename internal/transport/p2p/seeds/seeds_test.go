@@ -0,0 +1,97 @@
+package seeds
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeResolver answers from fixed records
+type fakeResolver struct {
+	srv   map[string][]*net.SRV
+	txt   map[string][]string
+	hosts map[string][]string
+}
+
+var errNoSuchHost = errors.New("no such host")
+
+func (f *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	records, ok := f.srv[name]
+	if !ok {
+		return "", nil, errNoSuchHost
+	}
+	return name, records, nil
+}
+
+func (f *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, ok := f.txt[name]
+	if !ok {
+		return nil, errNoSuchHost
+	}
+	return records, nil
+}
+
+func (f *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	addrs, ok := f.hosts[host]
+	if !ok {
+		return nil, errNoSuchHost
+	}
+	return addrs, nil
+}
+
+func TestDNSPeers(t *testing.T) {
+	resolver := &fakeResolver{
+		srv: map[string][]*net.SRV{
+			"_peervault._tcp.example.com": {
+				{Target: "a.example.com.", Port: 3000},
+				{Target: "b.example.com.", Port: 3001},
+			},
+		},
+		txt: map[string][]string{
+			"seeds.example.com": {"10.0.0.1:3000, n2@10.0.0.2:3000", "10.0.0.3:3000"},
+		},
+		hosts: map[string][]string{
+			"pool.example.com": {"10.1.0.1", "fd00::1"},
+		},
+	}
+	dns := &DNS{
+		Names:    []string{"_peervault._tcp.example.com", "seeds.example.com", "pool.example.com:4000", "missing.example.com"},
+		Resolver: resolver,
+	}
+	peers, err := dns.Peers(context.Background())
+	require.NoError(t, err, "names that fail are skipped")
+	assert.Equal(t, []Peer{
+		{Addr: "a.example.com:3000"},
+		{Addr: "b.example.com:3001"},
+		{Addr: "10.0.0.1:3000"},
+		{ID: "n2", Addr: "10.0.0.2:3000"},
+		{Addr: "10.0.0.3:3000"},
+		{Addr: "10.1.0.1:4000"},
+		{Addr: "[fd00::1]:4000"},
+	}, peers)
+
+	// The lookup fails when every name fails
+	dns.Names = []string{"missing.example.com"}
+	_, err = dns.Peers(context.Background())
+	assert.ErrorIs(t, err, errNoSuchHost)
+
+	// Malformed TXT entries fail the name
+	resolver.txt["bad.example.com"] = []string{"10.0.0.1"}
+	dns.Names = []string{"bad.example.com"}
+	_, err = dns.Peers(context.Background())
+	assert.Error(t, err)
+}
+
+func TestParsePeer(t *testing.T) {
+	p, err := ParsePeer("node1@host:3000")
+	require.NoError(t, err)
+	assert.Equal(t, Peer{ID: "node1", Addr: "host:3000"}, p)
+	for _, s := range []string{"host", ":3000", "host:0", "host:x", "id@host"} {
+		_, err := ParsePeer(s)
+		assert.Error(t, err, s)
+	}
+}
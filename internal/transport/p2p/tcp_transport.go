@@ -1,10 +1,17 @@
 package p2p
 
 import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/codec"
+	"github.com/Skpow1234/Peervault/internal/crypto"
 )
 
 // TCPPeer represents the remote node over a TCP established connection.
@@ -17,6 +24,19 @@ type TCPPeer struct {
 	outbound bool
 
 	wg *sync.WaitGroup
+
+	keyMu     sync.RWMutex
+	linkKeys  crypto.LinkKeys
+	nodeID    string
+	publicKey ed25519.PublicKey
+	version   uint8
+	codec     uint8
+
+	// writeMu orders sealed messages, numbered by sendSeq as they go out;
+	// recvSeq numbers those read, in the read loop
+	writeMu sync.Mutex
+	sendSeq uint64
+	recvSeq uint64
 }
 
 func NewTCPPeer(conn net.Conn, outbound bool) *TCPPeer {
@@ -29,17 +49,133 @@ func NewTCPPeer(conn net.Conn, outbound bool) *TCPPeer {
 
 func (p *TCPPeer) CloseStream() { p.wg.Done() }
 
+// SetLinkKeys stores the sub-keys derived for this link during the handshake
+func (p *TCPPeer) SetLinkKeys(keys crypto.LinkKeys) {
+	p.keyMu.Lock()
+	defer p.keyMu.Unlock()
+	p.linkKeys = keys
+}
+
+// SetNodeID records the node ID the peer proved during the handshake
+func (p *TCPPeer) SetNodeID(id string) {
+	p.keyMu.Lock()
+	defer p.keyMu.Unlock()
+	p.nodeID = id
+}
+
+// NodeID returns the node ID of the peer, or "" when the handshake did not
+// authenticate one
+func (p *TCPPeer) NodeID() string {
+	p.keyMu.RLock()
+	defer p.keyMu.RUnlock()
+	return p.nodeID
+}
+
+// SetPublicKey records the identity key the peer proved during the handshake
+func (p *TCPPeer) SetPublicKey(key ed25519.PublicKey) {
+	p.keyMu.Lock()
+	defer p.keyMu.Unlock()
+	p.publicKey = key
+}
+
+// PublicKey returns the identity key of the peer, or nil when it presented
+// none
+func (p *TCPPeer) PublicKey() ed25519.PublicKey {
+	p.keyMu.RLock()
+	defer p.keyMu.RUnlock()
+	return p.publicKey
+}
+
+// SetProtocolVersion records the protocol version negotiated with the peer
+func (p *TCPPeer) SetProtocolVersion(version uint8) {
+	p.keyMu.Lock()
+	defer p.keyMu.Unlock()
+	p.version = version
+}
+
+// ProtocolVersion returns the protocol version negotiated with the peer;
+// ProtocolVersion1 when the handshake negotiated none
+func (p *TCPPeer) ProtocolVersion() uint8 {
+	p.keyMu.RLock()
+	defer p.keyMu.RUnlock()
+	return max(p.version, ProtocolVersion1)
+}
+
+// SetCodec records the ID of the message codec negotiated with the peer
+func (p *TCPPeer) SetCodec(id uint8) {
+	p.keyMu.Lock()
+	defer p.keyMu.Unlock()
+	p.codec = id
+}
+
+// Codec returns the ID of the message codec negotiated with the peer;
+// gob when the handshake negotiated none
+func (p *TCPPeer) Codec() uint8 {
+	p.keyMu.RLock()
+	defer p.keyMu.RUnlock()
+	if p.codec == 0 {
+		return codec.IDGob
+	}
+	return p.codec
+}
+
+// Outbound reports whether this node dialed the connection
+func (p *TCPPeer) Outbound() bool { return p.outbound }
+
+// LinkKeys returns the sub-keys derived for this link, if any
+func (p *TCPPeer) LinkKeys() crypto.LinkKeys {
+	p.keyMu.RLock()
+	defer p.keyMu.RUnlock()
+	return p.linkKeys
+}
+
 func (p *TCPPeer) Send(b []byte) error {
 	_, err := p.Write(b)
 	return err
 }
 
+// ConnHook observes and interferes with the connections and messages of a
+// transport. The chaos package implements it to inject faults.
+type ConnHook interface {
+	// OnConnect is called once the handshake with a peer succeeded; an
+	// error drops the connection
+	OnConnect(p *TCPPeer) error
+	// OnMessage is called with every message read from a peer, but not
+	// with streams, and returns the messages to deliver in its place
+	OnMessage(p *TCPPeer, rpc RPC) []RPC
+	// OnDisconnect is called when the connection to a peer is dropped
+	OnDisconnect(p *TCPPeer)
+}
+
 type TCPTransportOpts struct {
 	ListenAddr    string
 	HandshakeFunc HandshakeFunc
 	Decoder       Decoder
 	OnPeer        func(Peer) error
 	OnStream      func(Peer, io.Reader) error
+	// Hook, when set, sees every connection and message
+	Hook ConnHook
+	// Limits protect the transport from misbehaving peers; zero fields
+	// use DefaultLimits
+	Limits Limits
+	// Network opens the transport's connections; nil uses TCP
+	Network Network
+}
+
+// Network opens the connections of a transport. Transports use TCP unless
+// given another one, such as a host of a MemoryNetwork.
+type Network interface {
+	Listen(addr string) (net.Listener, error)
+	DialTimeout(addr string, timeout time.Duration) (net.Conn, error)
+}
+
+// tcpNetwork connects transports over TCP
+type tcpNetwork struct{}
+
+func (tcpNetwork) Listen(addr string) (net.Listener, error) { return net.Listen("tcp", addr) }
+
+func (tcpNetwork) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", addr, timeout)
 }
 
 type TCPTransport struct {
@@ -47,17 +183,26 @@ type TCPTransport struct {
 	listener net.Listener
 	rpcch    chan RPC
 	stopCh   chan struct{}
+	limiter  *connLimiter
 }
 
 func NewTCPTransport(opts TCPTransportOpts) *TCPTransport {
+	opts.Limits = opts.Limits.withDefaults()
 	// Use LengthPrefixedDecoder by default if no decoder is specified
 	if opts.Decoder == nil {
 		opts.Decoder = LengthPrefixedDecoder{}
 	}
+	if dec, ok := opts.Decoder.(LengthPrefixedDecoder); ok && dec.MaxSize == 0 && opts.Limits.MaxMessageSize > 0 {
+		opts.Decoder = LengthPrefixedDecoder{MaxSize: opts.Limits.MaxMessageSize}
+	}
+	if opts.Network == nil {
+		opts.Network = tcpNetwork{}
+	}
 	return &TCPTransport{
 		TCPTransportOpts: opts,
 		rpcch:            make(chan RPC, 1024),
 		stopCh:           make(chan struct{}),
+		limiter:          newConnLimiter(opts.Limits),
 	}
 }
 
@@ -86,7 +231,7 @@ func (t *TCPTransport) Close() error {
 
 // Dial implements the Transport interface.
 func (t *TCPTransport) Dial(addr string) error {
-	conn, err := net.Dial("tcp", addr)
+	conn, err := t.Network.DialTimeout(addr, max(t.Limits.HandshakeTimeout, 0))
 	if err != nil {
 		return err
 	}
@@ -96,7 +241,7 @@ func (t *TCPTransport) Dial(addr string) error {
 
 func (t *TCPTransport) ListenAndAccept() error {
 	var err error
-	t.listener, err = net.Listen("tcp", t.ListenAddr)
+	t.listener, err = t.Network.Listen(t.ListenAddr)
 	if err != nil {
 		return err
 	}
@@ -133,16 +278,33 @@ func (t *TCPTransport) startAcceptLoop() {
 func (t *TCPTransport) handleConn(conn net.Conn, outbound bool) {
 	var err error
 	defer func() {
-		slog.Error("dropping peer connection", slog.String("error", err.Error()))
+		if errors.Is(err, ErrJoined) {
+			slog.Info("closing join connection", slog.String("peer", conn.RemoteAddr().String()))
+		} else {
+			slog.Error("dropping peer connection", slog.String("error", err.Error()))
+		}
 		if closeErr := conn.Close(); closeErr != nil {
 			slog.Error("failed to close connection", slog.String("error", closeErr.Error()))
 		}
 	}()
 
+	if !outbound {
+		if err = t.limiter.admit(conn.RemoteAddr()); err != nil {
+			return
+		}
+		defer t.limiter.release(conn.RemoteAddr())
+	}
+
 	peer := NewTCPPeer(conn, outbound)
-	if err = t.HandshakeFunc(peer); err != nil {
+	if err = t.handshake(peer); err != nil {
 		return
 	}
+	if t.Hook != nil {
+		if err = t.Hook.OnConnect(peer); err != nil {
+			return
+		}
+		defer t.Hook.OnDisconnect(peer)
+	}
 	if t.OnPeer != nil {
 		if err = t.OnPeer(peer); err != nil {
 			return
@@ -150,12 +312,20 @@ func (t *TCPTransport) handleConn(conn net.Conn, outbound bool) {
 	}
 
 	// Read loop
+	messages := t.Limits.messageLimiter()
 	for {
 		rpc := RPC{}
 		err = t.Decoder.Decode(conn, &rpc)
 		if err != nil {
 			return
 		}
+		if messages != nil && !messages.Allow() {
+			err = fmt.Errorf("peer %s sends more than %v messages per second", conn.RemoteAddr(), t.Limits.MessageRate)
+			return
+		}
+		if err = openMessage(peer, &rpc); err != nil {
+			return
+		}
 		rpc.From = conn.RemoteAddr().String()
 		if rpc.Stream {
 			peer.wg.Add(1)
@@ -175,6 +345,44 @@ func (t *TCPTransport) handleConn(conn net.Conn, outbound bool) {
 			}
 			continue
 		}
-		t.rpcch <- rpc
+		if t.Hook == nil {
+			t.rpcch <- rpc
+			continue
+		}
+		for _, delivered := range t.Hook.OnMessage(peer, rpc) {
+			t.rpcch <- delivered
+		}
+	}
+}
+
+// openMessage opens a message read from peer if its link is sealed, and
+// refuses sealed messages on links without keys
+func openMessage(peer *TCPPeer, rpc *RPC) error {
+	if peer.sealed() {
+		return peer.open(rpc)
+	}
+	if !rpc.Stream && rpc.Version >= ProtocolVersion4 {
+		return fmt.Errorf("%w: sealed message on a link without keys", ErrUnsealed)
+	}
+	return nil
+}
+
+// handshake runs the handshake with peer within the handshake limits
+func (t *TCPTransport) handshake(peer *TCPPeer) error {
+	if !peer.outbound {
+		if !t.limiter.startHandshake() {
+			return errors.New("too many handshakes in progress")
+		}
+		defer t.limiter.endHandshake()
+	}
+	if t.Limits.HandshakeTimeout <= 0 {
+		return t.HandshakeFunc(peer)
+	}
+	if err := peer.SetDeadline(time.Now().Add(t.Limits.HandshakeTimeout)); err != nil {
+		return err
+	}
+	if err := t.HandshakeFunc(peer); err != nil {
+		return err
 	}
+	return peer.SetDeadline(time.Time{})
 }
@@ -0,0 +1,204 @@
+// Package upgrade versions the on-disk layout of a node and upgrades it,
+// one step per version, so a release changing how something is stored can
+// still open what earlier releases wrote.
+package upgrade
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// VersionFileName records the layout version in the directory upgraded
+	VersionFileName = "LAYOUT"
+	// BackupDirName is the directory, next to the version file, holding the
+	// copies of files taken before each upgrade
+	BackupDirName = ".upgrade"
+)
+
+// ErrTooNew is returned for a layout written by a newer release, which
+// this one could misread. Downgrading takes restoring the backup taken
+// before the upgrade.
+var ErrTooNew = errors.New("upgrade: on-disk layout is newer than this release supports")
+
+// Step upgrades the layout from the version before it to the next. A step
+// interrupted by a crash runs again on the next start, so steps must be
+// idempotent.
+type Step struct {
+	Description string
+	// Apply makes the change, or with dryRun only works out what it would
+	// change, returning a line per change; a step with nothing to change
+	// returns none
+	Apply func(dryRun bool) ([]string, error)
+}
+
+// Options configure an upgrade
+type Options struct {
+	// Dir holds the version file and the backups
+	Dir string
+	// Steps upgrade the layout from version 0, the layout of nodes that
+	// predate versioning: Steps[i] upgrades version i to version i+1. Steps
+	// are only ever appended, so the last version is the one this release
+	// writes.
+	Steps []Step
+	// Backup lists the files copied aside before any step runs; missing
+	// files are skipped
+	Backup []string
+	// DryRun reports the steps that would run and what they would change,
+	// changing nothing
+	DryRun bool
+}
+
+// StepReport is what a step changed, or would change on a dry run
+type StepReport struct {
+	Version     int      `json:"version"`
+	Description string   `json:"description"`
+	Changes     []string `json:"changes"`
+}
+
+// Report is the result of an upgrade
+type Report struct {
+	From   int  `json:"from"`
+	To     int  `json:"to"`
+	DryRun bool `json:"dry_run"`
+	// Backup is the directory the files were copied to; empty when none
+	// was taken
+	Backup string       `json:"backup,omitempty"`
+	Steps  []StepReport `json:"steps"`
+}
+
+type versionFile struct {
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Version returns the layout version recorded in dir, 0 when none is
+func Version(dir string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(dir, VersionFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var vf versionFile
+	if err := json.Unmarshal(data, &vf); err != nil {
+		return 0, fmt.Errorf("corrupt layout version %s: %w", filepath.Join(dir, VersionFileName), err)
+	}
+	return vf.Version, nil
+}
+
+// Run upgrades the layout in dir to the last version of the steps. The
+// files to back up are copied first; the version is recorded after each
+// step, so an upgrade that fails resumes from the failed step.
+func Run(opts Options) (*Report, error) {
+	current, err := Version(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	latest := len(opts.Steps)
+	if current > latest {
+		return nil, fmt.Errorf("%w: %s is at version %d, this release supports up to %d", ErrTooNew, opts.Dir, current, latest)
+	}
+
+	report := &Report{From: current, To: current, DryRun: opts.DryRun, Steps: []StepReport{}}
+	if current == latest {
+		return report, nil
+	}
+	if !opts.DryRun {
+		if report.Backup, err = backup(opts.Dir, opts.Backup, current, latest); err != nil {
+			return report, fmt.Errorf("backing up before upgrading: %w", err)
+		}
+	}
+	for v := current; v < latest; v++ {
+		step := opts.Steps[v]
+		changes, err := step.Apply(opts.DryRun)
+		if err != nil {
+			return report, fmt.Errorf("upgrading layout to version %d (%s): %w", v+1, step.Description, err)
+		}
+		if !opts.DryRun {
+			if err := setVersion(opts.Dir, v+1); err != nil {
+				return report, err
+			}
+		}
+		report.To = v + 1
+		report.Steps = append(report.Steps, StepReport{Version: v + 1, Description: step.Description, Changes: changes})
+	}
+	return report, nil
+}
+
+// backup copies the files that exist to a new directory under
+// BackupDirName, returning it; nothing is created when none exists
+func backup(dir string, files []string, from, to int) (string, error) {
+	var existing []string
+	names := make(map[string]bool)
+	for _, file := range files {
+		if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		name := filepath.Base(file)
+		if names[name] {
+			return "", fmt.Errorf("two files to back up are named %s", name)
+		}
+		names[name] = true
+		existing = append(existing, file)
+	}
+	if len(existing) == 0 {
+		return "", nil
+	}
+
+	target := filepath.Join(dir, BackupDirName, fmt.Sprintf("%d-to-%d-%s", from, to, time.Now().UTC().Format("20060102T150405Z")))
+	if err := os.MkdirAll(target, 0700); err != nil {
+		return "", err
+	}
+	for _, file := range existing {
+		if err := copyFile(file, filepath.Join(target, filepath.Base(file))); err != nil {
+			return "", err
+		}
+	}
+	return target, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func setVersion(dir string, v int) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.Marshal(versionFile{Version: v, UpdatedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, VersionFileName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,124 @@
+package upgrade
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder returns steps appending their version to ran when they apply
+func recorder(ran *[]int, n int) []Step {
+	steps := make([]Step, n)
+	for i := range steps {
+		steps[i] = Step{Description: "step", Apply: func(dryRun bool) ([]string, error) {
+			if !dryRun {
+				*ran = append(*ran, i+1)
+			}
+			return []string{"change"}, nil
+		}}
+	}
+	return steps
+}
+
+func TestRun_UpgradesInOrder(t *testing.T) {
+	dir := t.TempDir()
+	index := filepath.Join(t.TempDir(), "index.json")
+	require.NoError(t, os.WriteFile(index, []byte(`{"a":1}`), 0600))
+
+	var ran []int
+	report, err := Run(Options{Dir: dir, Steps: recorder(&ran, 3), Backup: []string{index, filepath.Join(dir, "missing")}})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, ran)
+	assert.Equal(t, 0, report.From)
+	assert.Equal(t, 3, report.To)
+	assert.Len(t, report.Steps, 3)
+	version, err := Version(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 3, version)
+
+	require.NotEmpty(t, report.Backup)
+	backedUp, err := os.ReadFile(filepath.Join(report.Backup, "index.json"))
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, string(backedUp))
+
+	// A later release adds a step, which runs alone
+	ran = nil
+	report, err = Run(Options{Dir: dir, Steps: recorder(&ran, 4), Backup: []string{index}})
+	require.NoError(t, err)
+	assert.Equal(t, []int{4}, ran)
+	assert.Equal(t, 3, report.From)
+
+	ran = nil
+	report, err = Run(Options{Dir: dir, Steps: recorder(&ran, 4)})
+	require.NoError(t, err)
+	assert.Empty(t, ran)
+	assert.Empty(t, report.Steps)
+	assert.Empty(t, report.Backup)
+}
+
+func TestRun_DryRunChangesNothing(t *testing.T) {
+	dir := t.TempDir()
+	index := filepath.Join(dir, "index.json")
+	require.NoError(t, os.WriteFile(index, nil, 0600))
+
+	var ran []int
+	report, err := Run(Options{Dir: dir, Steps: recorder(&ran, 2), Backup: []string{index}, DryRun: true})
+	require.NoError(t, err)
+	assert.Empty(t, ran)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 2, report.To)
+	assert.Equal(t, []StepReport{
+		{Version: 1, Description: "step", Changes: []string{"change"}},
+		{Version: 2, Description: "step", Changes: []string{"change"}},
+	}, report.Steps)
+	assert.NoFileExists(t, filepath.Join(dir, VersionFileName))
+	assert.NoDirExists(t, filepath.Join(dir, BackupDirName))
+}
+
+func TestRun_ResumesFromFailedStep(t *testing.T) {
+	dir := t.TempDir()
+	var ran []int
+	steps := recorder(&ran, 3)
+	apply := steps[1].Apply
+	steps[1].Apply = func(bool) ([]string, error) { return nil, errors.New("disk full") }
+
+	report, err := Run(Options{Dir: dir, Steps: steps})
+	require.ErrorContains(t, err, "disk full")
+	assert.Equal(t, 1, report.To)
+	version, err := Version(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 1, version)
+
+	steps[1].Apply = apply
+	_, err = Run(Options{Dir: dir, Steps: steps})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, ran)
+}
+
+func TestRun_RefusesNewerLayout(t *testing.T) {
+	dir := t.TempDir()
+	var ran []int
+	_, err := Run(Options{Dir: dir, Steps: recorder(&ran, 3)})
+	require.NoError(t, err)
+
+	_, err = Run(Options{Dir: dir, Steps: recorder(&ran, 2)})
+	assert.ErrorIs(t, err, ErrTooNew)
+	_, err = Run(Options{Dir: dir, Steps: recorder(&ran, 2), DryRun: true})
+	assert.ErrorIs(t, err, ErrTooNew)
+}
+
+func TestRun_BackupNamesCollide(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(t.TempDir(), "index.json"), filepath.Join(t.TempDir(), "index.json")
+	for _, f := range []string{a, b} {
+		require.NoError(t, os.WriteFile(f, nil, 0600))
+	}
+	var ran []int
+	_, err := Run(Options{Dir: dir, Steps: recorder(&ran, 1), Backup: []string{a, b}})
+	assert.Error(t, err)
+	assert.Empty(t, ran, "no step runs without a backup")
+}
@@ -0,0 +1,472 @@
+// Package uploads stages resumable, chunked uploads. A client creates an
+// upload for a file of known size, sends its parts in any order and in
+// parallel, asks which parts arrived after an interruption, and completes
+// the upload once every part is in. An upload of unknown size is streamed
+// instead: its content is appended in order, chunk by chunk, and it can be
+// completed at any point. Parts and chunks are kept on disk so an upload
+// survives a server restart.
+package uploads
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound         = errors.New("uploads: upload not found")
+	ErrInvalidPart      = errors.New("uploads: invalid part number")
+	ErrPartSize         = errors.New("uploads: part has the wrong size")
+	ErrChecksumMismatch = errors.New("uploads: part checksum mismatch")
+	ErrIncomplete       = errors.New("uploads: parts are missing")
+	ErrInvalidUpload    = errors.New("uploads: invalid upload")
+	// ErrOffset is returned for a chunk that does not start where the
+	// content of a streamed upload ends, or while another one is appended
+	ErrOffset = errors.New("uploads: chunk does not continue the upload")
+)
+
+const (
+	// DefaultPartSize is used when an upload is created without a part size
+	DefaultPartSize = 8 << 20
+	// MinPartSize and MaxPartSize bound the part size a client can ask for
+	MinPartSize = 256 << 10
+	MaxPartSize = 64 << 20
+	// MaxParts bounds the number of parts of one upload
+	MaxParts = 10000
+	// DefaultTTL is how long an upload without activity is kept
+	DefaultTTL = 24 * time.Hour
+
+	sessionFile = "upload.json"
+	contentFile = "content"
+)
+
+// Upload is a file being uploaded in parts. Part n covers the bytes from
+// n*PartSize; every part but the last is PartSize long. A streamed upload
+// has no parts, and its Size counts the bytes appended so far.
+type Upload struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	ContentType string            `json:"content_type,omitempty"`
+	Size        int64             `json:"size"`
+	PartSize    int64             `json:"part_size"`
+	Tags        []string          `json:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Parts       []int             `json:"parts"`
+	Streamed    bool              `json:"streamed,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// PartCount returns the number of parts the upload needs
+func (u *Upload) PartCount() int {
+	if u.Streamed {
+		return 0
+	}
+	return int((u.Size + u.PartSize - 1) / u.PartSize)
+}
+
+// PartLength returns the size of part n
+func (u *Upload) PartLength(n int) int64 {
+	if n == u.PartCount()-1 {
+		return u.Size - int64(n)*u.PartSize
+	}
+	return u.PartSize
+}
+
+// Complete reports whether every part has arrived
+func (u *Upload) Complete() bool {
+	return u.Streamed || len(u.Parts) == u.PartCount()
+}
+
+func (u *Upload) hasPart(n int) bool {
+	i := sort.SearchInts(u.Parts, n)
+	return i < len(u.Parts) && u.Parts[i] == n
+}
+
+func (u *Upload) clone() *Upload {
+	c := *u
+	c.Parts = append([]int(nil), u.Parts...)
+	return &c
+}
+
+// CreateOptions describes a new upload
+type CreateOptions struct {
+	Name        string
+	ContentType string
+	Size        int64
+	// PartSize defaults to DefaultPartSize
+	PartSize int64
+	Tags     []string
+	Metadata map[string]string
+	// Streamed starts an upload of unknown size whose content is appended
+	// with Append; Size and PartSize are ignored
+	Streamed bool
+}
+
+// ManagerOpts configures a Manager
+type ManagerOpts struct {
+	// Dir holds the parts of unfinished uploads
+	Dir string
+	// TTL is how long an idle upload is kept; defaults to DefaultTTL
+	TTL    time.Duration
+	Logger *slog.Logger
+}
+
+// Manager keeps track of unfinished uploads
+type Manager struct {
+	dir     string
+	ttl     time.Duration
+	logger  *slog.Logger
+	mu      sync.Mutex
+	uploads map[string]*Upload
+	// appending holds the streamed uploads a chunk is appended to
+	appending map[string]bool
+}
+
+// NewManager creates a manager staging parts in opts.Dir, picking up the
+// uploads left there by a previous run
+func NewManager(opts ManagerOpts) (*Manager, error) {
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("uploads: no staging directory")
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if err := os.MkdirAll(opts.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("uploads: %w", err)
+	}
+
+	m := &Manager{
+		dir:       opts.Dir,
+		ttl:       opts.TTL,
+		logger:    opts.Logger,
+		uploads:   make(map[string]*Upload),
+		appending: make(map[string]bool),
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Create starts an upload
+func (m *Manager) Create(opts CreateOptions) (*Upload, error) {
+	if opts.Streamed {
+		opts.Size, opts.PartSize = 0, 0
+	} else if opts.PartSize == 0 {
+		opts.PartSize = DefaultPartSize
+	}
+	switch {
+	case opts.Name == "":
+		return nil, fmt.Errorf("%w: missing name", ErrInvalidUpload)
+	case opts.Size < 0:
+		return nil, fmt.Errorf("%w: negative size", ErrInvalidUpload)
+	case opts.Streamed:
+	case opts.PartSize < MinPartSize || opts.PartSize > MaxPartSize:
+		return nil, fmt.Errorf("%w: part size must be between %d and %d bytes", ErrInvalidUpload, MinPartSize, MaxPartSize)
+	case (opts.Size+opts.PartSize-1)/opts.PartSize > MaxParts:
+		return nil, fmt.Errorf("%w: more than %d parts; use a larger part size", ErrInvalidUpload, MaxParts)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	upload := &Upload{
+		ID:          id,
+		Name:        opts.Name,
+		ContentType: opts.ContentType,
+		Size:        opts.Size,
+		PartSize:    opts.PartSize,
+		Tags:        opts.Tags,
+		Metadata:    opts.Metadata,
+		Parts:       []int{},
+		Streamed:    opts.Streamed,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(now)
+	if err := os.MkdirAll(m.uploadDir(id), 0700); err != nil {
+		return nil, fmt.Errorf("uploads: %w", err)
+	}
+	if err := m.save(upload); err != nil {
+		os.RemoveAll(m.uploadDir(id))
+		return nil, err
+	}
+	m.uploads[id] = upload
+	return upload.clone(), nil
+}
+
+// Get returns an upload with the parts received so far
+func (m *Manager) Get(id string) (*Upload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, ok := m.uploads[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return upload.clone(), nil
+}
+
+// PutPart stores part n read from r. A non-empty checksum is the hex
+// SHA-256 the part must have. Sending a part again replaces it, so a
+// client can retry a part whose response it never saw.
+func (m *Manager) PutPart(id string, n int, r io.Reader, checksum string) (string, error) {
+	upload, err := m.Get(id)
+	if err != nil {
+		return "", err
+	}
+	if upload.Streamed {
+		return "", fmt.Errorf("%w: a streamed upload has no parts", ErrInvalidPart)
+	}
+	if n < 0 || n >= upload.PartCount() {
+		return "", fmt.Errorf("%w: %d (upload has %d parts)", ErrInvalidPart, n, upload.PartCount())
+	}
+	want := upload.PartLength(n)
+
+	// Write to a temporary file so a failed or concurrent retry never
+	// leaves a torn part behind
+	tmp, err := os.CreateTemp(m.uploadDir(id), fmt.Sprintf("part-%05d-*", n))
+	if err != nil {
+		return "", fmt.Errorf("uploads: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(r, want+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("uploads: failed to store part %d: %w", n, err)
+	}
+	if written != want {
+		return "", fmt.Errorf("%w: part %d is %d bytes, expected %d", ErrPartSize, n, written, want)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if checksum != "" && checksum != sum {
+		return "", fmt.Errorf("%w: part %d", ErrChecksumMismatch, n)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, ok := m.uploads[id]
+	if !ok {
+		return "", ErrNotFound
+	}
+	if err := os.Rename(tmp.Name(), m.partPath(id, n)); err != nil {
+		return "", fmt.Errorf("uploads: %w", err)
+	}
+	if !current.hasPart(n) {
+		current.Parts = append(current.Parts, n)
+		sort.Ints(current.Parts)
+	}
+	current.UpdatedAt = time.Now().UTC()
+	return sum, m.save(current)
+}
+
+// Append adds the chunk read from r to the content of a streamed upload,
+// which must end at offset, and returns the new size. A chunk that fails
+// is not kept, so the client can send it again.
+func (m *Manager) Append(id string, offset int64, r io.Reader) (int64, error) {
+	m.mu.Lock()
+	upload, ok := m.uploads[id]
+	switch {
+	case !ok:
+		m.mu.Unlock()
+		return 0, ErrNotFound
+	case !upload.Streamed:
+		m.mu.Unlock()
+		return 0, fmt.Errorf("%w: the upload is sent in parts", ErrInvalidUpload)
+	case m.appending[id] || offset != upload.Size:
+		m.mu.Unlock()
+		return 0, fmt.Errorf("%w: at %d, the upload has %d bytes", ErrOffset, offset, upload.Size)
+	}
+	m.appending[id] = true
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.appending, id)
+		m.mu.Unlock()
+	}()
+
+	f, err := os.OpenFile(m.contentPath(id), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("uploads: %w", err)
+	}
+	written, err := io.Copy(io.NewOffsetWriter(f, offset), r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		// Drop what was written of the chunk
+		f.Truncate(offset)
+		f.Close()
+		return 0, fmt.Errorf("uploads: failed to append to the upload: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return 0, fmt.Errorf("uploads: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, ok := m.uploads[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	current.Size = offset + written
+	current.UpdatedAt = time.Now().UTC()
+	return current.Size, m.save(current)
+}
+
+// Open returns the assembled content of a complete upload
+func (m *Manager) Open(id string) (io.ReadCloser, error) {
+	upload, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Streamed {
+		f, err := os.OpenFile(m.contentPath(id), os.O_CREATE|os.O_RDONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("uploads: %w", err)
+		}
+		// Bytes of a chunk that was cut short are past Size
+		return &assembled{Reader: io.LimitReader(f, upload.Size), files: []*os.File{f}}, nil
+	}
+	if !upload.Complete() {
+		return nil, fmt.Errorf("%w: %d of %d received", ErrIncomplete, len(upload.Parts), upload.PartCount())
+	}
+
+	files := make([]*os.File, 0, upload.PartCount())
+	readers := make([]io.Reader, 0, upload.PartCount())
+	for n := 0; n < upload.PartCount(); n++ {
+		f, err := os.Open(m.partPath(id, n))
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, fmt.Errorf("uploads: %w", err)
+		}
+		files = append(files, f)
+		readers = append(readers, f)
+	}
+	return &assembled{Reader: io.MultiReader(readers...), files: files}, nil
+}
+
+// Delete removes an upload and its parts, after completion or to abort it
+func (m *Manager) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.uploads[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.uploads, id)
+	return os.RemoveAll(m.uploadDir(id))
+}
+
+// expire drops uploads idle for longer than the TTL. Callers hold m.mu.
+func (m *Manager) expire(now time.Time) {
+	for id, upload := range m.uploads {
+		if now.Sub(upload.UpdatedAt) > m.ttl {
+			m.logger.Info("Removing abandoned upload", "id", id, "name", upload.Name)
+			delete(m.uploads, id)
+			if err := os.RemoveAll(m.uploadDir(id)); err != nil {
+				m.logger.Warn("Failed to remove abandoned upload", "id", id, "error", err)
+			}
+		}
+	}
+}
+
+func (m *Manager) load() error {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return fmt.Errorf("uploads: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(m.dir, entry.Name(), sessionFile))
+		if err != nil {
+			continue
+		}
+		var upload Upload
+		if err := json.Unmarshal(data, &upload); err != nil || upload.ID != entry.Name() {
+			m.logger.Warn("Ignoring unreadable upload", "dir", entry.Name())
+			continue
+		}
+		m.uploads[upload.ID] = &upload
+	}
+	m.expire(time.Now())
+	return nil
+}
+
+func (m *Manager) save(upload *Upload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return fmt.Errorf("uploads: %w", err)
+	}
+	path := filepath.Join(m.uploadDir(upload.ID), sessionFile)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("uploads: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("uploads: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) uploadDir(id string) string {
+	return filepath.Join(m.dir, id)
+}
+
+func (m *Manager) contentPath(id string) string {
+	return filepath.Join(m.uploadDir(id), contentFile)
+}
+
+func (m *Manager) partPath(id string, n int) string {
+	return filepath.Join(m.uploadDir(id), fmt.Sprintf("part-%05d", n))
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("uploads: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// assembled reads the parts of an upload in order
+type assembled struct {
+	io.Reader
+	files []*os.File
+}
+
+func (a *assembled) Close() error {
+	var err error
+	for _, f := range a.files {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
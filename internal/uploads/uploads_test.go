@@ -0,0 +1,156 @@
+package uploads
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadPartsOutOfOrder(t *testing.T) {
+	m, err := NewManager(ManagerOpts{Dir: t.TempDir()})
+	require.NoError(t, err)
+
+	content := bytes.Repeat([]byte("0123456789"), MinPartSize/4)
+	upload, err := m.Create(CreateOptions{Name: "big.bin", Size: int64(len(content)), PartSize: MinPartSize})
+	require.NoError(t, err)
+	require.Equal(t, 3, upload.PartCount())
+	assert.Equal(t, int64(len(content)-2*MinPartSize), upload.PartLength(2))
+
+	part := func(n int) []byte {
+		end := min((n+1)*MinPartSize, len(content))
+		return content[n*MinPartSize : end]
+	}
+	for _, n := range []int{2, 0} {
+		sum := sha256.Sum256(part(n))
+		got, err := m.PutPart(upload.ID, n, bytes.NewReader(part(n)), hex.EncodeToString(sum[:]))
+		require.NoError(t, err)
+		assert.Equal(t, hex.EncodeToString(sum[:]), got)
+	}
+
+	_, err = m.Open(upload.ID)
+	assert.ErrorIs(t, err, ErrIncomplete)
+
+	// A rejected part is not recorded
+	_, err = m.PutPart(upload.ID, 1, bytes.NewReader(part(1)), strings.Repeat("0", 64))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	_, err = m.PutPart(upload.ID, 1, bytes.NewReader(part(1)[1:]), "")
+	assert.ErrorIs(t, err, ErrPartSize)
+	_, err = m.PutPart(upload.ID, 3, bytes.NewReader(part(1)), "")
+	assert.ErrorIs(t, err, ErrInvalidPart)
+	got, err := m.Get(upload.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 2}, got.Parts)
+
+	_, err = m.PutPart(upload.ID, 1, bytes.NewReader(part(1)), "")
+	require.NoError(t, err)
+	r, err := m.Open(upload.ID)
+	require.NoError(t, err)
+	assembled, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, content, assembled)
+
+	require.NoError(t, m.Delete(upload.ID))
+	_, err = m.Get(upload.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestUploadSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManager(ManagerOpts{Dir: dir})
+	require.NoError(t, err)
+	upload, err := m.Create(CreateOptions{Name: "a.txt", Size: MinPartSize + 1, PartSize: MinPartSize})
+	require.NoError(t, err)
+	_, err = m.PutPart(upload.ID, 1, strings.NewReader("x"), "")
+	require.NoError(t, err)
+
+	m, err = NewManager(ManagerOpts{Dir: dir})
+	require.NoError(t, err)
+	got, err := m.Get(upload.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1}, got.Parts)
+	assert.Equal(t, "a.txt", got.Name)
+
+	// Uploads idle past the TTL are dropped
+	time.Sleep(10 * time.Millisecond)
+	m, err = NewManager(ManagerOpts{Dir: dir, TTL: time.Millisecond})
+	require.NoError(t, err)
+	_, err = m.Get(upload.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoDirExists(t, m.uploadDir(upload.ID))
+}
+
+func TestCreateValidates(t *testing.T) {
+	m, err := NewManager(ManagerOpts{Dir: t.TempDir()})
+	require.NoError(t, err)
+
+	_, err = m.Create(CreateOptions{Size: 10})
+	assert.ErrorIs(t, err, ErrInvalidUpload)
+	_, err = m.Create(CreateOptions{Name: "a", Size: -1})
+	assert.ErrorIs(t, err, ErrInvalidUpload)
+	_, err = m.Create(CreateOptions{Name: "a", Size: 10, PartSize: 1})
+	assert.ErrorIs(t, err, ErrInvalidUpload)
+	_, err = m.Create(CreateOptions{Name: "a", Size: (MaxParts + 1) * MinPartSize, PartSize: MinPartSize})
+	assert.ErrorIs(t, err, ErrInvalidUpload)
+
+	// An empty file has no parts and is complete straight away
+	upload, err := m.Create(CreateOptions{Name: "empty", Size: 0})
+	require.NoError(t, err)
+	assert.Equal(t, 0, upload.PartCount())
+	r, err := m.Open(upload.ID)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+	require.NoError(t, r.Close())
+}
+
+func TestStreamedUpload(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManager(ManagerOpts{Dir: dir})
+	require.NoError(t, err)
+	upload, err := m.Create(CreateOptions{Name: "blob", Streamed: true})
+	require.NoError(t, err)
+	assert.True(t, upload.Complete())
+
+	size, err := m.Append(upload.ID, 0, strings.NewReader("hello "))
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), size)
+
+	// Chunks must continue the content
+	_, err = m.Append(upload.ID, 0, strings.NewReader("again"))
+	assert.ErrorIs(t, err, ErrOffset)
+	_, err = m.Append(upload.ID, 7, strings.NewReader("gap"))
+	assert.ErrorIs(t, err, ErrOffset)
+	_, err = m.PutPart(upload.ID, 0, strings.NewReader("part"), "")
+	assert.ErrorIs(t, err, ErrInvalidPart)
+
+	// A failed chunk is dropped
+	_, err = m.Append(upload.ID, 6, io.MultiReader(strings.NewReader("wor"), iotest.ErrReader(io.ErrUnexpectedEOF)))
+	assert.Error(t, err)
+
+	// The content survives a restart
+	m, err = NewManager(ManagerOpts{Dir: dir})
+	require.NoError(t, err)
+	_, err = m.Append(upload.ID, 6, strings.NewReader("world"))
+	require.NoError(t, err)
+	r, err := m.Open(upload.ID)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, "hello world", string(data))
+
+	parts, err := m.Create(CreateOptions{Name: "parts", Size: 1})
+	require.NoError(t, err)
+	_, err = m.Append(parts.ID, 0, strings.NewReader("x"))
+	assert.ErrorIs(t, err, ErrInvalidUpload)
+}
@@ -0,0 +1,124 @@
+// Package vclock implements version vectors, which tell whether one write
+// to a key saw another. Every node that writes a key increments its own
+// counter in the key's vector; a write whose vector is at least the other's
+// in every counter happened after it, and two writes where neither is are
+// concurrent: they conflict, and neither may silently replace the other.
+package vclock
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Order is how two vectors relate
+type Order int
+
+const (
+	// Equal vectors describe the same write
+	Equal Order = iota
+	// Before means the vector is an ancestor of the other
+	Before
+	// After means the vector descends from the other
+	After
+	// Concurrent vectors were written without seeing each other
+	Concurrent
+)
+
+func (o Order) String() string {
+	switch o {
+	case Equal:
+		return "equal"
+	case Before:
+		return "before"
+	case After:
+		return "after"
+	default:
+		return "concurrent"
+	}
+}
+
+// Vector counts the writes of each node by node ID. The nil vector is the
+// ancestor of every other.
+type Vector map[string]uint64
+
+// Compare tells how v relates to o
+func (v Vector) Compare(o Vector) Order {
+	less, greater := false, false
+	for node, n := range v {
+		if n > o[node] {
+			greater = true
+		}
+	}
+	for node, n := range o {
+		if n > v[node] {
+			less = true
+		}
+	}
+	switch {
+	case less && greater:
+		return Concurrent
+	case less:
+		return Before
+	case greater:
+		return After
+	default:
+		return Equal
+	}
+}
+
+// Descends reports whether v is equal to o or comes after it
+func (v Vector) Descends(o Vector) bool {
+	order := v.Compare(o)
+	return order == Equal || order == After
+}
+
+// Increment returns a copy of v with the counter of node incremented
+func (v Vector) Increment(node string) Vector {
+	c := v.Clone()
+	c[node]++
+	return c
+}
+
+// Merge returns the smallest vector descending from both v and o
+func (v Vector) Merge(o Vector) Vector {
+	c := v.Clone()
+	for node, n := range o {
+		c[node] = max(c[node], n)
+	}
+	return c
+}
+
+// Clone returns a copy of v, which is never nil
+func (v Vector) Clone() Vector {
+	c := make(Vector, len(v))
+	maps.Copy(c, v)
+	return c
+}
+
+// String formats v as node:count pairs sorted by node ID, leaving out
+// zero counters
+func (v Vector) String() string {
+	var b strings.Builder
+	for _, node := range slices.Sorted(maps.Keys(v)) {
+		if v[node] == 0 {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(node)
+		b.WriteByte(':')
+		b.WriteString(strconv.FormatUint(v[node], 10))
+	}
+	return b.String()
+}
+
+// ID returns a short identifier of v that is the same for equal vectors
+func (v Vector) ID() string {
+	sum := sha256.Sum256([]byte(v.String()))
+	return hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,58 @@
+package vclock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Vector
+		want Order
+	}{
+		{"both empty", nil, Vector{}, Equal},
+		{"zero counters", Vector{"a": 0}, nil, Equal},
+		{"same", Vector{"a": 1, "b": 2}, Vector{"a": 1, "b": 2}, Equal},
+		{"ancestor", Vector{"a": 1}, Vector{"a": 2}, Before},
+		{"missing node", Vector{"a": 1}, Vector{"a": 1, "b": 1}, Before},
+		{"descendant", Vector{"a": 2, "b": 1}, Vector{"a": 1}, After},
+		{"nil is before all", nil, Vector{"a": 1}, Before},
+		{"concurrent", Vector{"a": 1}, Vector{"b": 1}, Concurrent},
+		{"concurrent counters", Vector{"a": 2, "b": 1}, Vector{"a": 1, "b": 2}, Concurrent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.a.Compare(tt.b))
+			reverse := map[Order]Order{Equal: Equal, Before: After, After: Before, Concurrent: Concurrent}
+			assert.Equal(t, reverse[tt.want], tt.b.Compare(tt.a))
+		})
+	}
+}
+
+func TestIncrementAndMerge(t *testing.T) {
+	var v Vector
+	a := v.Increment("a")
+	assert.Nil(t, v, "increment copies")
+	assert.Equal(t, Vector{"a": 1}, a)
+
+	b := a.Increment("b")
+	c := a.Increment("c")
+	assert.Equal(t, Concurrent, b.Compare(c))
+
+	merged := b.Merge(c)
+	assert.Equal(t, Vector{"a": 1, "b": 1, "c": 1}, merged)
+	assert.True(t, merged.Descends(b))
+	assert.True(t, merged.Descends(c))
+	assert.Equal(t, After, merged.Increment("a").Compare(merged))
+	assert.Equal(t, Vector{"a": 1, "b": 1}, b, "merge copies")
+}
+
+func TestStringAndID(t *testing.T) {
+	v := Vector{"b": 2, "a": 1, "c": 0}
+	assert.Equal(t, "a:1,b:2", v.String())
+	assert.Equal(t, v.ID(), Vector{"a": 1, "b": 2}.ID())
+	assert.NotEqual(t, v.ID(), Vector{"a": 1, "b": 3}.ID())
+	assert.Len(t, v.ID(), 12)
+}
@@ -0,0 +1,194 @@
+// Package watch keeps a journal of the changes to keys, so clients can
+// follow the changes under a prefix instead of polling listings, and resume
+// where they left off after a disconnect.
+//
+// Every event carries a resume token. Watching with the token of the last
+// event received delivers the changes made since, as long as the journal
+// still holds them; otherwise the watch fails with ErrExpired and the
+// client lists the keys again. A client that lists first takes Token
+// before listing, then watches from it, so no change falls in between.
+package watch
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHistory is how many changes a journal keeps for resuming
+	DefaultHistory = 1000
+	// watchBuffer is how many events a watcher may fall behind before its
+	// channel is closed; it resumes with the token of the last event
+	watchBuffer = 256
+)
+
+var (
+	// ErrExpired is returned for resume tokens older than the changes the
+	// journal holds, or issued before the node restarted
+	ErrExpired = errors.New("resume token expired; list the keys and watch again")
+	// ErrInvalidToken is returned for malformed resume tokens
+	ErrInvalidToken = errors.New("invalid resume token")
+)
+
+// Op is the change an event reports
+type Op string
+
+const (
+	Created Op = "created"
+	Updated Op = "updated"
+	Deleted Op = "deleted"
+)
+
+// Event is a change to a key
+type Event struct {
+	Op   Op     `json:"op"`
+	Key  string `json:"key"`
+	Size int64  `json:"size,omitempty"`
+	// Token resumes a watch after this event
+	Token string    `json:"token"`
+	Time  time.Time `json:"time"`
+}
+
+// Journal records changes and hands them to watchers. The zero value is
+// not usable; call New.
+type Journal struct {
+	mu sync.Mutex
+	// epoch tells the tokens of this journal from those of an earlier one,
+	// whose sequence numbers mean other changes
+	epoch    string
+	seq      uint64
+	history  int
+	events   []Event
+	watchers map[*watcher]struct{}
+}
+
+// watcher is a watch on a prefix
+type watcher struct {
+	prefix string
+	ch     chan Event
+	closed bool
+}
+
+// New creates a journal keeping the last history changes, DefaultHistory
+// when zero
+func New(history int) *Journal {
+	if history <= 0 {
+		history = DefaultHistory
+	}
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return &Journal{
+		epoch:    hex.EncodeToString(b),
+		history:  history,
+		watchers: make(map[*watcher]struct{}),
+	}
+}
+
+// Token returns the token of the last change, which watches the changes
+// made from now on
+func (j *Journal) Token() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.token(j.seq)
+}
+
+func (j *Journal) token(seq uint64) string {
+	return j.epoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+// Record records a change and hands it to the watchers of its key
+func (j *Journal) Record(op Op, key string, size int64) Event {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.seq++
+	e := Event{Op: op, Key: key, Size: size, Token: j.token(j.seq), Time: time.Now()}
+	j.events = append(j.events, e)
+	if len(j.events) > j.history {
+		j.events = j.events[len(j.events)-j.history:]
+	}
+	for w := range j.watchers {
+		if !strings.HasPrefix(key, w.prefix) {
+			continue
+		}
+		select {
+		case w.ch <- e:
+		default:
+			// Fell behind; it resumes from its last event
+			j.closeLocked(w)
+		}
+	}
+	return e
+}
+
+// Watch returns the changes to keys under prefix made after token, then
+// those made from now on, until ctx is done. An empty token watches from
+// now on. The channel is also closed when the client falls too far behind;
+// it watches again with the token of the last event it received.
+func (j *Journal) Watch(ctx context.Context, prefix, token string) (<-chan Event, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var backlog []Event
+	if token != "" {
+		seq, err := j.parse(token)
+		if err != nil {
+			return nil, err
+		}
+		// The journal holds the changes after seq when it holds the one
+		// following it, or there are none yet
+		if seq < j.seq && (len(j.events) == 0 || j.seq-uint64(len(j.events)) > seq) {
+			return nil, ErrExpired
+		}
+		for _, e := range j.events[len(j.events)-int(j.seq-seq):] {
+			if strings.HasPrefix(e.Key, prefix) {
+				backlog = append(backlog, e)
+			}
+		}
+	}
+
+	w := &watcher{prefix: prefix, ch: make(chan Event, len(backlog)+watchBuffer)}
+	for _, e := range backlog {
+		w.ch <- e
+	}
+	j.watchers[w] = struct{}{}
+	go func() {
+		<-ctx.Done()
+		j.mu.Lock()
+		j.closeLocked(w)
+		j.mu.Unlock()
+	}()
+	return w.ch, nil
+}
+
+// parse returns the sequence number of a token of this journal
+func (j *Journal) parse(token string) (uint64, error) {
+	epoch, n, ok := strings.Cut(token, "-")
+	if !ok {
+		return 0, ErrInvalidToken
+	}
+	seq, err := strconv.ParseUint(n, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidToken, token)
+	}
+	if epoch != j.epoch || seq > j.seq {
+		return 0, ErrExpired
+	}
+	return seq, nil
+}
+
+func (j *Journal) closeLocked(w *watcher) {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	close(w.ch)
+	delete(j.watchers, w)
+}
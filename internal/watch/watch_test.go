@@ -0,0 +1,103 @@
+package watch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// drain returns the events ready on ch
+func drain(ch <-chan Event) []Event {
+	var events []Event
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return events
+			}
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func keys(events []Event) []string {
+	var result []string
+	for _, e := range events {
+		result = append(result, string(e.Op)+" "+e.Key)
+	}
+	return result
+}
+
+func TestWatchPrefix(t *testing.T) {
+	j := New(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := j.Watch(ctx, "docs/", "")
+	require.NoError(t, err)
+
+	j.Record(Created, "docs/a", 1)
+	j.Record(Created, "photos/b", 2)
+	j.Record(Updated, "docs/a", 3)
+	j.Record(Deleted, "docs/a", 0)
+	assert.Equal(t, []string{"created docs/a", "updated docs/a", "deleted docs/a"}, keys(drain(ch)))
+
+	cancel()
+	require.Eventually(t, func() bool {
+		_, ok := <-ch
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestWatchResume(t *testing.T) {
+	j := New(3)
+	start := j.Token()
+	first := j.Record(Created, "a", 1)
+	j.Record(Created, "b", 1)
+	j.Record(Created, "a/c", 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := j.Watch(ctx, "a", first.Token)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"created a/c"}, keys(drain(ch)))
+
+	// The journal still holds every change after the start
+	ch, err = j.Watch(ctx, "", start)
+	require.NoError(t, err)
+	assert.Len(t, drain(ch), 3)
+
+	// Not after one more
+	j.Record(Deleted, "b", 0)
+	_, err = j.Watch(ctx, "", start)
+	assert.ErrorIs(t, err, ErrExpired)
+	ch, err = j.Watch(ctx, "", first.Token)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"created b", "created a/c", "deleted b"}, keys(drain(ch)))
+
+	// Tokens of another journal, such as before a restart, have expired
+	_, err = j.Watch(ctx, "", New(0).Token())
+	assert.ErrorIs(t, err, ErrExpired)
+	_, err = j.Watch(ctx, "", "garbage")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestSlowWatcherIsClosed(t *testing.T) {
+	j := New(0)
+	ch, err := j.Watch(context.Background(), "", "")
+	require.NoError(t, err)
+	for range watchBuffer + 1 {
+		j.Record(Updated, "k", 1)
+	}
+	events := drain(ch)
+	require.Len(t, events, watchBuffer)
+	last := events[len(events)-1]
+
+	// It resumes from the last event it received
+	ch, err = j.Watch(context.Background(), "", last.Token)
+	require.NoError(t, err)
+	assert.Len(t, drain(ch), 1)
+}
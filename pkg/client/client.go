@@ -0,0 +1,293 @@
+// Package client is the Go client library for PeerVault. Client talks to
+// the REST API and GRPCClient to the gRPC API; both offer the same typed
+// operations for storing, fetching, listing and watching files and for
+// managing peers, with bearer token auth and retries of transient failures
+// built in.
+//
+//	c, err := client.New("http://localhost:8081", client.WithToken(token))
+//	if err != nil {
+//		return err
+//	}
+//	file, err := c.StoreFile(ctx, "report.pdf", &client.StoreOptions{Tags: []string{"q3"}})
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/Skpow1234/Peervault/internal/requestid"
+)
+
+// ErrNotFound is matched by errors for files, peers or uploads that do not
+// exist, whichever API reported them
+var ErrNotFound = errors.New("peervault: not found")
+
+// ErrPrecondition is matched by errors for conditional calls whose
+// condition the stored file did not meet
+var ErrPrecondition = errors.New("peervault: precondition failed")
+
+// ErrNotModified is returned by GetIfNoneMatch when the file still has the
+// ETag the caller holds
+var ErrNotModified = errors.New("peervault: not modified")
+
+// Condition is what a conditional call expects of the stored file by ETag,
+// the hash of its content that File.Hash holds. "*" matches any existing
+// file.
+type Condition struct {
+	// IfMatch lists ETags the file must have one of
+	IfMatch []string
+	// IfNoneMatch lists ETags the file must have none of; "*" stores only
+	// when no file exists
+	IfNoneMatch []string
+}
+
+// APIError is an error response from the REST API. Code, Details and
+// RequestID come from the JSON error body; quote RequestID when reporting
+// a failure, it names the request in the node's logs.
+type APIError struct {
+	StatusCode int            `json:"-"`
+	Code       string         `json:"code"`
+	Message    string         `json:"message"`
+	Details    map[string]any `json:"details"`
+	RequestID  string         `json:"request_id"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("peervault: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is makes errors.Is(err, ErrNotFound) true for 404 responses and
+// errors.Is(err, ErrPrecondition) for 412 ones
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrPrecondition:
+		return e.StatusCode == http.StatusPreconditionFailed
+	}
+	return false
+}
+
+// WithRequestID returns ctx carrying a request ID, which calls made with it
+// send to the server in place of one the server would generate
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return requestid.NewContext(ctx, id)
+}
+
+// Client is a PeerVault REST API client. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	token      string
+	userAgent  string
+	httpClient *http.Client
+	retry      RetryPolicy
+}
+
+// settings collects the options of both Client and GRPCClient
+type settings struct {
+	token       string
+	userAgent   string
+	httpClient  *http.Client
+	tlsConfig   *tls.Config
+	retry       RetryPolicy
+	dialOptions []grpc.DialOption
+}
+
+func newSettings(opts []Option) *settings {
+	s := &settings{userAgent: "peervault-go-client", retry: DefaultRetryPolicy()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Option configures a Client or GRPCClient
+type Option func(*settings)
+
+// WithToken authenticates requests with a bearer token
+func WithToken(token string) Option {
+	return func(s *settings) { s.token = token }
+}
+
+// WithHTTPClient sends REST requests through hc instead of a default
+// client. WithTLSConfig has no effect on it.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(s *settings) { s.httpClient = hc }
+}
+
+// WithTLSConfig uses cfg for TLS connections, e.g. for a private CA or
+// client certificates. A GRPCClient without it connects in plaintext.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(s *settings) { s.tlsConfig = cfg }
+}
+
+// WithRetry replaces the default retry policy
+func WithRetry(policy RetryPolicy) Option {
+	return func(s *settings) { s.retry = policy }
+}
+
+// WithUserAgent sets the user agent the server sees
+func WithUserAgent(userAgent string) Option {
+	return func(s *settings) { s.userAgent = userAgent }
+}
+
+// WithDialOptions passes additional options to grpc.NewClient
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(s *settings) { s.dialOptions = append(s.dialOptions, opts...) }
+}
+
+// New creates a client for the REST API at baseURL, e.g.
+// http://localhost:8081
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("peervault: invalid server URL %q", baseURL)
+	}
+
+	s := newSettings(opts)
+	httpClient := s.httpClient
+	if httpClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = s.tlsConfig
+		// No overall timeout, which would cut off large downloads; the
+		// context bounds a call
+		transport.ResponseHeaderTimeout = 30 * time.Second
+		httpClient = &http.Client{Transport: transport}
+	}
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		token:      s.token,
+		userAgent:  s.userAgent,
+		httpClient: httpClient,
+		retry:      s.retry,
+	}, nil
+}
+
+// Health checks that the server is up
+func (c *Client) Health(ctx context.Context) error {
+	resp, err := c.do(ctx, &request{method: "GET", path: "/health", idempotent: true})
+	if err != nil {
+		return err
+	}
+	return decode(resp, nil)
+}
+
+// request describes a REST call. body is called for every attempt, so a
+// request with a body can be retried.
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	body        func() (io.Reader, error)
+	contentType string
+	header      http.Header
+	// idempotent requests are retried after network errors and 5xx
+	// responses; others only when the server turned them away
+	idempotent bool
+	// once is set for bodies that cannot be sent again
+	once bool
+}
+
+// do sends a request, retrying transient failures. Responses with an
+// error status are returned as *APIError.
+func (c *Client) do(ctx context.Context, r *request) (*http.Response, error) {
+	target := c.baseURL + r.path
+	if len(r.query) > 0 {
+		target += "?" + r.query.Encode()
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < c.retry.attempts(); attempt++ {
+		if attempt > 0 {
+			if err := c.retry.wait(ctx, attempt); err != nil {
+				return nil, err
+			}
+		}
+
+		var body io.Reader
+		if r.body != nil {
+			var err error
+			if body, err = r.body(); err != nil {
+				return nil, err
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, r.method, target, body)
+		if err != nil {
+			return nil, fmt.Errorf("peervault: %w", err)
+		}
+		for k, v := range r.header {
+			req.Header[k] = v
+		}
+		if r.contentType != "" {
+			req.Header.Set("Content-Type", r.contentType)
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		req.Header.Set("User-Agent", c.userAgent)
+		requestid.Propagate(ctx, req)
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = fmt.Errorf("peervault: %w", err)
+			if r.idempotent && !r.once {
+				continue
+			}
+			return nil, lastErr
+		}
+		if resp.StatusCode < 400 {
+			return resp, nil
+		}
+
+		lastErr = apiError(resp)
+		if r.once || !retryable(resp.StatusCode, r.idempotent) {
+			return nil, lastErr
+		}
+	}
+	return nil, lastErr
+}
+
+func apiError(resp *http.Response) error {
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	e := &APIError{StatusCode: resp.StatusCode, RequestID: resp.Header.Get(requestid.Header)}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") && json.Unmarshal(body, e) == nil && e.Message != "" {
+		return e
+	}
+	return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body)), RequestID: e.RequestID}
+}
+
+// decode reads a JSON response into v, or discards it when v is nil
+func decode(resp *http.Response, v interface{}) error {
+	defer func() { _ = resp.Body.Close() }()
+	if v == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("peervault: failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// jsonBody encodes v once and replays it on every attempt
+func jsonBody(v interface{}) (func() (io.Reader, error), error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("peervault: %w", err)
+	}
+	return func() (io.Reader, error) { return strings.NewReader(string(data)), nil }, nil
+}
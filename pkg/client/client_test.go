@@ -0,0 +1,332 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/api/apierror"
+	"github.com/Skpow1234/Peervault/internal/api/rest/endpoints"
+	"github.com/Skpow1234/Peervault/internal/api/rest/implementations"
+	"github.com/Skpow1234/Peervault/internal/requestid"
+	"github.com/Skpow1234/Peervault/internal/uploads"
+	"github.com/Skpow1234/Peervault/pkg/e2e"
+	"github.com/Skpow1234/Peervault/pkg/merkle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer serves the file, upload and peer endpoints of the REST
+// API, passing requests through wrap when given
+func newTestServer(t *testing.T, wrap func(http.Handler) http.Handler) string {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	manager, err := uploads.NewManager(uploads.ManagerOpts{Dir: t.TempDir()})
+	require.NoError(t, err)
+	files := implementations.NewFileService()
+	fileEndpoints := endpoints.NewFileEndpoints(files, logger)
+	uploadEndpoints := endpoints.NewUploadEndpoints(implementations.NewUploadService(manager, files), logger)
+	peerEndpoints := endpoints.NewPeerEndpoints(implementations.NewPeerService(), logger)
+
+	api := http.NewServeMux()
+	api.HandleFunc("GET /files", fileEndpoints.HandleListFiles)
+	api.HandleFunc("POST /files", fileEndpoints.HandleUploadFile)
+	api.HandleFunc("DELETE /files", fileEndpoints.HandleDeleteFile)
+	api.HandleFunc("GET /files/get", fileEndpoints.HandleGetFile)
+	api.HandleFunc("GET /files/{key}/content", fileEndpoints.HandleDownloadFile)
+	api.HandleFunc("GET /files/{key}/manifest", fileEndpoints.HandleGetFileManifest)
+	api.HandleFunc("POST /uploads", uploadEndpoints.HandleCreateUpload)
+	api.HandleFunc("PUT /uploads/{id}/parts/{part}", uploadEndpoints.HandleUploadPart)
+	api.HandleFunc("POST /uploads/{id}/complete", uploadEndpoints.HandleCompleteUpload)
+	api.HandleFunc("DELETE /uploads/{id}", uploadEndpoints.HandleAbortUpload)
+	api.HandleFunc("GET /peers", peerEndpoints.HandleListPeers)
+	api.HandleFunc("POST /peers", peerEndpoints.HandleAddPeer)
+	api.HandleFunc("DELETE /peers", peerEndpoints.HandleRemovePeer)
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/", http.StripPrefix("/api/v1", api))
+
+	var handler http.Handler = mux
+	if wrap != nil {
+		handler = wrap(mux)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func fastRetry() Option {
+	return WithRetry(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+}
+
+func TestStoreGetListDelete(t *testing.T) {
+	c, err := New(newTestServer(t, nil), fastRetry())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	file, err := c.Store(ctx, "notes.txt", strings.NewReader("hello"), &StoreOptions{
+		ContentType: "text/plain",
+		Tags:        []string{"docs"},
+		Metadata:    map[string]string{"team": "infra"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), file.Size)
+	assert.Equal(t, "text/plain", file.ContentType)
+
+	stat, err := c.Stat(ctx, file.Key)
+	require.NoError(t, err)
+	assert.Equal(t, file.Hash, stat.Hash)
+
+	var buf bytes.Buffer
+	_, err = c.Download(ctx, file.Key, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", buf.String())
+
+	_, err = c.Store(ctx, "other.txt", strings.NewReader("x"), nil)
+	require.NoError(t, err)
+	listed, err := c.List(ctx, &ListOptions{Tags: []string{"docs"}, Metadata: map[string]string{"team": "infra"}})
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, file.Key, listed[0].Key)
+
+	require.NoError(t, c.Delete(ctx, file.Key))
+	_, err = c.Stat(ctx, file.Key)
+	assert.ErrorIs(t, err, ErrNotFound)
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+}
+
+func TestGetIfNoneMatch(t *testing.T) {
+	c, err := New(newTestServer(t, nil), fastRetry())
+	require.NoError(t, err)
+	ctx := context.Background()
+	file, err := c.Store(ctx, "notes.txt", strings.NewReader("hello"), nil)
+	require.NoError(t, err)
+
+	body, etag, err := c.GetIfNoneMatch(ctx, file.Key, "")
+	require.NoError(t, err)
+	data, _ := io.ReadAll(body)
+	_ = body.Close()
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, `"`+file.Hash+`"`, etag)
+
+	_, current, err := c.GetIfNoneMatch(ctx, file.Key, etag)
+	assert.ErrorIs(t, err, ErrNotModified)
+	assert.Equal(t, etag, current)
+
+	body, _, err = c.GetIfNoneMatch(ctx, file.Key, `"stale"`)
+	require.NoError(t, err)
+	_ = body.Close()
+}
+
+func TestAPIErrorModel(t *testing.T) {
+	url := newTestServer(t, func(next http.Handler) http.Handler {
+		return requestid.Middleware(apierror.Middleware(next))
+	})
+	c, err := New(url)
+	require.NoError(t, err)
+
+	_, err = c.Stat(WithRequestID(context.Background(), "trace-11"), "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, apierror.CodeNotFound, apiErr.Code)
+	assert.Equal(t, "File not found", apiErr.Message)
+	assert.Equal(t, "trace-11", apiErr.RequestID)
+}
+
+func TestStoreFileInParts(t *testing.T) {
+	c, err := New(newTestServer(t, nil))
+	require.NoError(t, err)
+
+	data := make([]byte, 3*uploads.MinPartSize+100)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "big.bin")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	file, err := c.StoreFile(context.Background(), path, &StoreOptions{PartSize: uploads.MinPartSize, Parallel: 2, Tags: []string{"big"}})
+	require.NoError(t, err)
+	assert.Equal(t, "big.bin", file.Name)
+	assert.Equal(t, []string{"big"}, file.Tags)
+
+	body, err := c.Get(context.Background(), file.Key)
+	require.NoError(t, err)
+	defer func() { _ = body.Close() }()
+	got, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, got))
+}
+
+func TestDownloadVerified(t *testing.T) {
+	c, err := New(newTestServer(t, nil))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	data := make([]byte, 2*merkle.DefaultChunkSize+100)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+	file, err := c.Store(ctx, "big.bin", bytes.NewReader(data), nil)
+	require.NoError(t, err)
+	assert.Equal(t, merkle.RootOf(data), file.MerkleRoot)
+
+	m, err := c.Manifest(ctx, file.Key)
+	require.NoError(t, err)
+	assert.Len(t, m.Chunks, 3)
+
+	var got bytes.Buffer
+	n, err := c.DownloadVerified(ctx, file.Key, "", &got)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.True(t, bytes.Equal(data, got.Bytes()))
+
+	_, err = c.DownloadVerified(ctx, file.Key, merkle.RootOf([]byte("other")), io.Discard)
+	assert.ErrorIs(t, err, merkle.ErrMismatch)
+}
+
+func TestStoreEncrypted(t *testing.T) {
+	c, err := New(newTestServer(t, nil))
+	require.NoError(t, err)
+	ctx := context.Background()
+	passphrase, err := e2e.NewPassphrase("hunter2")
+	require.NoError(t, err)
+	key, err := e2e.GenerateKey()
+	require.NoError(t, err)
+
+	small, err := c.Store(ctx, "notes.txt", strings.NewReader("top secret"), &StoreOptions{
+		Metadata:   map[string]string{"team": "infra"},
+		Encryption: passphrase,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, e2e.SchemePassphrase, small.Metadata[e2e.MetaScheme])
+	assert.Equal(t, "infra", small.Metadata["team"])
+
+	data := make([]byte, 2*uploads.MinPartSize+100)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "big.bin")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	big, err := c.StoreFile(ctx, path, &StoreOptions{PartSize: uploads.MinPartSize, Encryption: key})
+	require.NoError(t, err)
+	assert.Equal(t, e2e.EncryptedSize(int64(len(data))), big.Size)
+	assert.Equal(t, key.ID(), big.Metadata[e2e.MetaKeyID])
+
+	// The node holds ciphertext only
+	var stored bytes.Buffer
+	_, err = c.Download(ctx, small.Key, &stored)
+	require.NoError(t, err)
+	assert.NotContains(t, stored.String(), "top secret")
+
+	var plain bytes.Buffer
+	_, err = c.DownloadDecrypted(ctx, small.Key, &plain, passphrase)
+	require.NoError(t, err)
+	assert.Equal(t, "top secret", plain.String())
+	plain.Reset()
+	_, err = c.DownloadDecrypted(ctx, big.Key, &plain, key)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, plain.Bytes()))
+
+	_, err = c.DownloadDecrypted(ctx, big.Key, io.Discard, passphrase)
+	assert.ErrorIs(t, err, e2e.ErrWrongKey)
+	wrong, err := e2e.NewPassphrase("hunter3")
+	require.NoError(t, err)
+	_, err = c.DownloadDecrypted(ctx, small.Key, io.Discard, wrong)
+	assert.ErrorIs(t, err, e2e.ErrWrongKey)
+}
+
+func TestRetriesTransientFailures(t *testing.T) {
+	var calls atomic.Int32
+	failFirst := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	url := newTestServer(t, failFirst)
+
+	// A seekable body is sent again
+	c, err := New(url, fastRetry())
+	require.NoError(t, err)
+	file, err := c.Store(context.Background(), "a.txt", strings.NewReader("retried"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), file.Size)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// A stream cannot be, so the error is returned
+	calls.Store(0)
+	_, err = c.Store(context.Background(), "b.txt", io.MultiReader(strings.NewReader("once")), nil)
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+
+	calls.Store(0)
+	c, err = New(url, WithRetry(NoRetry()))
+	require.NoError(t, err)
+	_, err = c.Peers(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestSendsToken(t *testing.T) {
+	var auth atomic.Value
+	url := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth.Store(r.Header.Get("Authorization"))
+			next.ServeHTTP(w, r)
+		})
+	})
+	c, err := New(url, WithToken("secret"))
+	require.NoError(t, err)
+
+	peer, err := c.AddPeer(context.Background(), "10.0.0.2", 3000, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer secret", auth.Load())
+	assert.Equal(t, "10.0.0.2", peer.Address)
+	_, err = c.Peers(context.Background())
+	require.NoError(t, err)
+}
+
+func TestWatch(t *testing.T) {
+	c, err := New(newTestServer(t, nil))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := c.Watch(ctx, &WatchOptions{Interval: 10 * time.Millisecond, ListOptions: ListOptions{Tags: []string{"watched"}}})
+	require.NoError(t, err)
+
+	file, err := c.Store(ctx, "w.txt", strings.NewReader("1"), &StoreOptions{Tags: []string{"watched"}})
+	require.NoError(t, err)
+	event := <-w.Events()
+	assert.Equal(t, EventCreated, event.Type)
+	assert.Equal(t, file.Key, event.File.Key)
+
+	require.NoError(t, c.Delete(ctx, file.Key))
+	event = <-w.Events()
+	assert.Equal(t, EventDeleted, event.Type)
+
+	cancel()
+	for range w.Events() {
+	}
+	assert.NoError(t, w.Err())
+}
+
+func TestNewRejectsBadURL(t *testing.T) {
+	_, err := New("localhost:8081")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrNotFound))
+}
@@ -0,0 +1,524 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skpow1234/Peervault/pkg/e2e"
+	"github.com/Skpow1234/Peervault/pkg/merkle"
+)
+
+const (
+	// DefaultPartSize is the part size of chunked uploads; StoreFile sends
+	// smaller files in a single request
+	DefaultPartSize = 8 << 20
+	// DefaultParallel is the number of parts uploaded at once
+	DefaultParallel = 4
+)
+
+// File describes a stored file
+type File struct {
+	Key         string            `json:"key"`
+	Name        string            `json:"name"`
+	Size        int64             `json:"size"`
+	ContentType string            `json:"content_type"`
+	Hash        string            `json:"hash"`
+	MerkleRoot  string            `json:"merkle_root,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+}
+
+// StoreOptions describes a file being stored
+type StoreOptions struct {
+	ContentType string
+	Tags        []string
+	Metadata    map[string]string
+	// PartSize is the part size StoreFile splits large files into;
+	// defaults to DefaultPartSize
+	PartSize int64
+	// Parallel is the number of parts StoreFile uploads at once; defaults
+	// to DefaultParallel
+	Parallel int
+	// Encryption encrypts the content before it leaves the client, with a
+	// key or passphrase the nodes never see. The scheme is recorded in the
+	// metadata of the file; DownloadDecrypted reads it back.
+	Encryption *e2e.Key
+}
+
+// metadata returns the metadata of the file, with the scheme recorded for
+// encrypted files
+func (o *StoreOptions) metadata() map[string]string {
+	if o.Encryption == nil {
+		return o.Metadata
+	}
+	meta := make(map[string]string, len(o.Metadata)+2)
+	for k, v := range o.Metadata {
+		meta[k] = v
+	}
+	for k, v := range o.Encryption.Metadata() {
+		meta[k] = v
+	}
+	return meta
+}
+
+// ListOptions narrows a listing down to files carrying all of the tags
+// and metadata values
+type ListOptions struct {
+	Tags     []string
+	Metadata map[string]string
+}
+
+func (o *ListOptions) query() url.Values {
+	query := url.Values{}
+	if o == nil {
+		return query
+	}
+	for _, tag := range o.Tags {
+		query.Add("tag", tag)
+	}
+	for k, v := range o.Metadata {
+		query.Set("meta."+k, v)
+	}
+	return query
+}
+
+// Store streams the content of r into a new file called name. The request
+// is retried after transient failures only when r is an io.Seeker.
+func (c *Client) Store(ctx context.Context, name string, r io.Reader, opts *StoreOptions) (*File, error) {
+	if opts == nil {
+		opts = &StoreOptions{}
+	}
+	var metadata string
+	if meta := opts.metadata(); len(meta) > 0 {
+		data, err := json.Marshal(meta)
+		if err != nil {
+			return nil, fmt.Errorf("peervault: %w", err)
+		}
+		metadata = string(data)
+	}
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	seeker, seekable := r.(io.Seeker)
+
+	// The form is written into a pipe as the transport reads it. A retry
+	// waits for the writer of the previous attempt, which stops once the
+	// transport closes the pipe, before rewinding r.
+	var done chan struct{}
+	body := func() (io.Reader, error) {
+		if done != nil {
+			<-done
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return nil, fmt.Errorf("peervault: %w", err)
+			}
+		}
+		done = make(chan struct{})
+		pr, pw := io.Pipe()
+		go func(done chan struct{}) {
+			defer close(done)
+			pw.CloseWithError(writeForm(pw, boundary, name, r, opts, metadata))
+		}(done)
+		return pr, nil
+	}
+
+	resp, err := c.do(ctx, &request{
+		method:      "POST",
+		path:        "/api/v1/files",
+		body:        body,
+		contentType: "multipart/form-data; boundary=" + boundary,
+		once:        !seekable,
+	})
+	if done != nil {
+		<-done
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file File
+	if err := decode(resp, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func writeForm(w io.Writer, boundary, name string, r io.Reader, opts *StoreOptions, metadata string) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	if metadata != "" {
+		if err := mw.WriteField("metadata", metadata); err != nil {
+			return err
+		}
+	}
+	if len(opts.Tags) > 0 {
+		if err := mw.WriteField("tags", strings.Join(opts.Tags, ",")); err != nil {
+			return err
+		}
+	}
+
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, name))
+	header.Set("Content-Type", contentType)
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	if opts.Encryption != nil {
+		// Every attempt seals the content with a new salt and nonce
+		enc, err := opts.Encryption.NewEncrypter()
+		if err != nil {
+			return err
+		}
+		if _, err := enc.Encrypt(part, r); err != nil {
+			return err
+		}
+	} else if _, err := io.Copy(part, r); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+// StoreFile stores the file at path under its base name. Files larger than
+// one part are sent as a chunked upload, several parts at a time, each
+// checked against its SHA-256 by the server and retried on its own.
+func (c *Client) StoreFile(ctx context.Context, path string, opts *StoreOptions) (*File, error) {
+	if opts == nil {
+		opts = &StoreOptions{}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("peervault: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("peervault: %w", err)
+	}
+	if !stat.Mode().IsRegular() {
+		return nil, fmt.Errorf("peervault: %s is not a regular file", path)
+	}
+
+	partSize := opts.PartSize
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+	name := filepath.Base(path)
+	if stat.Size() <= partSize {
+		return c.Store(ctx, name, f, opts)
+	}
+	return c.storeParts(ctx, name, f, stat.Size(), partSize, opts)
+}
+
+type uploadInfo struct {
+	ID string `json:"id"`
+}
+
+func (c *Client) storeParts(ctx context.Context, name string, f *os.File, size, partSize int64, opts *StoreOptions) (*File, error) {
+	var content io.ReaderAt = f
+	if opts.Encryption != nil {
+		enc, err := opts.Encryption.NewEncrypter()
+		if err != nil {
+			return nil, fmt.Errorf("peervault: %w", err)
+		}
+		content = enc.ReaderAt(f, size)
+		size = e2e.EncryptedSize(size)
+	}
+	body, err := jsonBody(map[string]interface{}{
+		"name":         name,
+		"content_type": opts.ContentType,
+		"size":         size,
+		"part_size":    partSize,
+		"metadata":     opts.metadata(),
+		"tags":         opts.Tags,
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, &request{method: "POST", path: "/api/v1/uploads", body: body, contentType: "application/json"})
+	if err != nil {
+		return nil, err
+	}
+	var upload uploadInfo
+	if err := decode(resp, &upload); err != nil {
+		return nil, err
+	}
+
+	if err := c.uploadParts(ctx, upload.ID, content, size, partSize, opts.Parallel); err != nil {
+		c.abortUpload(upload.ID)
+		return nil, err
+	}
+
+	resp, err = c.do(ctx, &request{method: "POST", path: "/api/v1/uploads/" + url.PathEscape(upload.ID) + "/complete"})
+	if err != nil {
+		c.abortUpload(upload.ID)
+		return nil, err
+	}
+	var file File
+	if err := decode(resp, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// uploadParts sends the parts of f with parallel workers; the first part
+// that fails stops the others
+func (c *Client) uploadParts(ctx context.Context, uploadID string, f io.ReaderAt, size, partSize int64, parallel int) error {
+	if parallel <= 0 {
+		parallel = DefaultParallel
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parts := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range parts {
+				offset := int64(part) * partSize
+				if err := c.uploadPart(ctx, uploadID, part, f, offset, min(partSize, size-offset)); err != nil {
+					once.Do(func() {
+						firstErr = fmt.Errorf("peervault: part %d: %w", part, err)
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for part := 0; int64(part)*partSize < size; part++ {
+		select {
+		case parts <- part:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(parts)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func (c *Client) uploadPart(ctx context.Context, uploadID string, part int, f io.ReaderAt, offset, length int64) error {
+	data := make([]byte, length)
+	if _, err := f.ReadAt(data, offset); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	resp, err := c.do(ctx, &request{
+		method:      "PUT",
+		path:        "/api/v1/uploads/" + url.PathEscape(uploadID) + "/parts/" + strconv.Itoa(part),
+		body:        func() (io.Reader, error) { return bytes.NewReader(data), nil },
+		contentType: "application/octet-stream",
+		header:      http.Header{"X-Content-Sha256": {hex.EncodeToString(sum[:])}},
+		idempotent:  true,
+	})
+	if err != nil {
+		return err
+	}
+	return decode(resp, nil)
+}
+
+// abortUpload discards a failed upload on a best effort basis
+func (c *Client) abortUpload(uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := c.do(ctx, &request{method: "DELETE", path: "/api/v1/uploads/" + url.PathEscape(uploadID), idempotent: true})
+	if err == nil {
+		_ = decode(resp, nil)
+	}
+}
+
+// Stat returns the description of the file stored under key
+func (c *Client) Stat(ctx context.Context, key string) (*File, error) {
+	resp, err := c.do(ctx, &request{method: "GET", path: "/api/v1/files/get", query: url.Values{"key": {key}}, idempotent: true})
+	if err != nil {
+		return nil, err
+	}
+	var file File
+	if err := decode(resp, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// Get opens the content of the file stored under key. The caller must
+// close the returned reader.
+func (c *Client) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, &request{method: "GET", path: "/api/v1/files/" + url.PathEscape(key) + "/content", idempotent: true})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// GetIfNoneMatch opens the content of the file stored under key along with
+// its ETag, unless the file still has the ETag etag, in which case it fails
+// with ErrNotModified. An empty etag always opens the content. The caller
+// must close the returned reader.
+func (c *Client) GetIfNoneMatch(ctx context.Context, key, etag string) (io.ReadCloser, string, error) {
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	resp, err := c.do(ctx, &request{method: "GET", path: "/api/v1/files/" + url.PathEscape(key) + "/content", header: header, idempotent: true})
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		_ = resp.Body.Close()
+		return nil, etag, ErrNotModified
+	}
+	return resp.Body, resp.Header.Get("ETag"), nil
+}
+
+// Download writes the content of the file stored under key to w
+func (c *Client) Download(ctx context.Context, key string, w io.Writer) (int64, error) {
+	body, err := c.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = body.Close() }()
+	n, err := io.Copy(w, body)
+	if err != nil {
+		return n, fmt.Errorf("peervault: %w", err)
+	}
+	return n, nil
+}
+
+// DownloadDecrypted writes the plaintext of a file stored with
+// StoreOptions.Encryption to w. The metadata of the file is checked first,
+// so a wrong key or passphrase fails with e2e.ErrWrongKey before the
+// content is fetched; a file stored in plaintext fails with
+// e2e.ErrNotEncrypted.
+func (c *Client) DownloadDecrypted(ctx context.Context, key string, w io.Writer, k *e2e.Key) (int64, error) {
+	file, err := c.Stat(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if err := k.Check(file.Metadata); err != nil {
+		return 0, err
+	}
+	body, err := c.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = body.Close() }()
+	return k.Decrypt(w, body)
+}
+
+// Manifest returns the Merkle tree of the file stored under key, its
+// content cut into chunks of merkle.DefaultChunkSize
+func (c *Client) Manifest(ctx context.Context, key string) (*merkle.Manifest, error) {
+	resp, err := c.do(ctx, &request{method: "GET", path: "/api/v1/files/" + url.PathEscape(key) + "/manifest", idempotent: true})
+	if err != nil {
+		return nil, err
+	}
+	var m merkle.Manifest
+	if err := decode(resp, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// DownloadVerified writes the content of the file stored under key to w,
+// one chunk at a time, each only once it matches the Merkle tree of the
+// file. The tree is checked against root, the merkle_root of the file the
+// caller trusts, or the one Stat reports when root is empty. Content that
+// does not match fails with merkle.ErrMismatch, leaving w with the chunks
+// verified before.
+func (c *Client) DownloadVerified(ctx context.Context, key, root string, w io.Writer) (int64, error) {
+	if root == "" {
+		file, err := c.Stat(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		root = file.MerkleRoot
+	}
+	m, err := c.Manifest(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if err := m.Verify(); err != nil {
+		return 0, err
+	}
+	if m.Root != root {
+		return 0, fmt.Errorf("%w: root %s, expected %s", merkle.ErrMismatch, m.Root, root)
+	}
+
+	body, err := c.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = body.Close() }()
+	var written int64
+	buf := make([]byte, m.ChunkSize)
+	for i := range m.Chunks {
+		_, length := m.Chunk(i)
+		if _, err := io.ReadFull(body, buf[:length]); err != nil {
+			return written, fmt.Errorf("peervault: %w", err)
+		}
+		if err := m.VerifyChunk(i, buf[:length]); err != nil {
+			return written, err
+		}
+		n, err := w.Write(buf[:length])
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// List returns the stored files, all of them when opts is nil
+func (c *Client) List(ctx context.Context, opts *ListOptions) ([]File, error) {
+	resp, err := c.do(ctx, &request{method: "GET", path: "/api/v1/files", query: opts.query(), idempotent: true})
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Files []File `json:"files"`
+	}
+	if err := decode(resp, &list); err != nil {
+		return nil, err
+	}
+	return list.Files, nil
+}
+
+// Delete removes the file stored under key
+func (c *Client) Delete(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, &request{method: "DELETE", path: "/api/v1/files", query: url.Values{"key": {key}}, idempotent: true})
+	if err != nil {
+		return err
+	}
+	return decode(resp, nil)
+}
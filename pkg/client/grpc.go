@@ -0,0 +1,446 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/Skpow1234/Peervault/internal/requestid"
+	"github.com/Skpow1234/Peervault/proto/peervault"
+)
+
+// grpcChunkSize is the size of the chunks Store streams
+const grpcChunkSize = 64 << 10
+
+// GRPCCodec encodes the PeerVault messages, which are plain Go structs, as
+// JSON. Clients use it for every call; a server has to be created with
+// grpc.ForceServerCodec(GRPCCodec) to talk to them.
+var GRPCCodec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return "json" }
+
+// GRPCClient is a PeerVault gRPC API client. It is safe for concurrent use.
+type GRPCClient struct {
+	conn *grpc.ClientConn
+	raw  peervault.PeerVaultServiceClient
+}
+
+// DialGRPC creates a client for the gRPC API at target, e.g.
+// localhost:50051. The connection is established lazily by the first call.
+func DialGRPC(target string, opts ...Option) (*GRPCClient, error) {
+	s := newSettings(opts)
+
+	transport := insecure.NewCredentials()
+	if s.tlsConfig != nil {
+		transport = credentials.NewTLS(s.tlsConfig)
+	}
+	dialOptions := []grpc.DialOption{
+		grpc.WithTransportCredentials(transport),
+		grpc.WithUserAgent(s.userAgent),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(GRPCCodec)),
+		grpc.WithChainUnaryInterceptor(unaryRequestID),
+		grpc.WithChainStreamInterceptor(streamRequestID),
+	}
+	if s.token != "" {
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(bearerToken(s.token)))
+	}
+	if config := retryServiceConfig(s.retry); config != "" {
+		dialOptions = append(dialOptions, grpc.WithDefaultServiceConfig(config))
+	}
+	dialOptions = append(dialOptions, s.dialOptions...)
+
+	conn, err := grpc.NewClient(target, dialOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("peervault: %w", err)
+	}
+	return &GRPCClient{conn: conn, raw: peervault.NewPeerVaultServiceClient(conn)}, nil
+}
+
+// retryServiceConfig has gRPC retry calls that failed with UNAVAILABLE,
+// which it only does while the server has not started processing them
+func retryServiceConfig(p RetryPolicy) string {
+	if p.attempts() < 2 {
+		return ""
+	}
+	seconds := func(d, fallback time.Duration) string {
+		if d <= 0 {
+			d = fallback
+		}
+		return fmt.Sprintf("%.3fs", d.Seconds())
+	}
+	return fmt.Sprintf(`{"methodConfig": [{
+		"name": [{"service": "peervault.PeerVaultService"}],
+		"retryPolicy": {
+			"maxAttempts": %d,
+			"initialBackoff": %q,
+			"maxBackoff": %q,
+			"backoffMultiplier": 2,
+			"retryableStatusCodes": ["UNAVAILABLE"]
+		}
+	}]}`, p.attempts(), seconds(p.InitialBackoff, time.Millisecond), seconds(p.MaxBackoff, 5*time.Second))
+}
+
+// bearerToken sends the token with every call. Like the REST client it
+// does not insist on TLS, so plaintext connections to a local node work.
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t bearerToken) RequireTransportSecurity() bool { return false }
+
+// unaryRequestID sends the request ID set with WithRequestID
+func unaryRequestID(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(outgoingRequestID(ctx), method, req, reply, cc, opts...)
+}
+
+// streamRequestID sends the request ID set with WithRequestID
+func streamRequestID(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(outgoingRequestID(ctx), desc, cc, method, opts...)
+}
+
+func outgoingRequestID(ctx context.Context) context.Context {
+	if id := requestid.FromContext(ctx); id != "" {
+		return metadata.AppendToOutgoingContext(ctx, strings.ToLower(requestid.Header), id)
+	}
+	return ctx
+}
+
+// grpcError makes errors.Is(err, ErrNotFound) true for NotFound statuses
+// while keeping the status for status.FromError
+func grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch status.Code(err) {
+	case codes.NotFound:
+		return &codeError{err: err, target: ErrNotFound}
+	case codes.FailedPrecondition:
+		return &codeError{err: err, target: ErrPrecondition}
+	}
+	return err
+}
+
+// codeError makes errors.Is match a status error with the error of this
+// package for its code
+type codeError struct {
+	err    error
+	target error
+}
+
+func (e *codeError) Error() string        { return e.err.Error() }
+func (e *codeError) Unwrap() error        { return e.err }
+func (e *codeError) Is(target error) bool { return target == e.target }
+
+// GRPCStatus lets status.FromError and status.Code see through the wrapper
+func (e *codeError) GRPCStatus() *status.Status { return status.Convert(e.err) }
+
+// Raw returns the generated client for calls this package does not wrap
+func (g *GRPCClient) Raw() peervault.PeerVaultServiceClient {
+	return g.raw
+}
+
+// Close closes the connection
+func (g *GRPCClient) Close() error {
+	return g.conn.Close()
+}
+
+func timeOf(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+func fileFromProto(f *peervault.FileResponse) *File {
+	return &File{
+		Key:         f.Key,
+		Name:        f.Name,
+		Size:        f.Size,
+		ContentType: f.ContentType,
+		Hash:        f.Hash,
+		CreatedAt:   timeOf(f.CreatedAt),
+		UpdatedAt:   timeOf(f.UpdatedAt),
+		Metadata:    f.Metadata,
+	}
+}
+
+func peerFromProto(p *peervault.PeerResponse) *Peer {
+	return &Peer{
+		ID:        p.Id,
+		Address:   p.Address,
+		Port:      int(p.Port),
+		Status:    p.Status,
+		LastSeen:  timeOf(p.LastSeen),
+		CreatedAt: timeOf(p.CreatedAt),
+		Metadata:  p.Metadata,
+	}
+}
+
+// Store streams the content of r into the file stored under key
+func (g *GRPCClient) Store(ctx context.Context, key string, r io.Reader) (*File, error) {
+	return g.StoreIf(ctx, key, r, Condition{})
+}
+
+// StoreIf stores like Store when the file stored under key meets cond,
+// failing with ErrPrecondition otherwise, so writers holding a stale
+// File.Hash do not overwrite each other's updates
+func (g *GRPCClient) StoreIf(ctx context.Context, key string, r io.Reader, cond Condition) (*File, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := g.raw.UploadFile(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	buf := make([]byte, grpcChunkSize)
+	var offset int64
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			sum := sha256.Sum256(buf[:n])
+			chunk := &peervault.FileChunk{FileKey: key, Data: buf[:n], Offset: offset, Checksum: hex.EncodeToString(sum[:])}
+			if offset == 0 {
+				chunk.IfMatch, chunk.IfNoneMatch = cond.IfMatch, cond.IfNoneMatch
+			}
+			if err := stream.Send(chunk); err != nil {
+				// The server ended the stream; CloseAndRecv has the reason
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return nil, grpcError(err)
+			}
+			offset += int64(n)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("peervault: %w", readErr)
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return fileFromProto(resp), nil
+}
+
+// Download writes the content of the file stored under key to w as the
+// server streams it
+func (g *GRPCClient) Download(ctx context.Context, key string, w io.Writer) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := g.raw.DownloadFile(ctx, &peervault.FileRequest{Key: key})
+	if err != nil {
+		return 0, grpcError(err)
+	}
+
+	var written int64
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, grpcError(err)
+		}
+		n, err := w.Write(chunk.Data)
+		written += int64(n)
+		if err != nil {
+			return written, fmt.Errorf("peervault: %w", err)
+		}
+	}
+}
+
+// Get opens the content of the file stored under key. The caller must
+// close the returned reader.
+func (g *GRPCClient) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	go func() {
+		defer cancel()
+		_, err := g.Download(ctx, key, pw)
+		pw.CloseWithError(err)
+	}()
+	return &cancelReader{ReadCloser: pr, cancel: cancel}, nil
+}
+
+// cancelReader stops the download behind a pipe when closed early
+type cancelReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelReader) Close() error {
+	c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// Stat returns the description of the file stored under key
+func (g *GRPCClient) Stat(ctx context.Context, key string) (*File, error) {
+	resp, err := g.raw.GetFile(ctx, &peervault.FileRequest{Key: key})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return fileFromProto(resp), nil
+}
+
+// List returns the files matching filter, all of them when it is empty,
+// fetching as many pages as needed
+func (g *GRPCClient) List(ctx context.Context, filter string) ([]File, error) {
+	const pageSize = 100
+	var files []File
+	for page := int32(1); ; page++ {
+		resp, err := g.raw.ListFiles(ctx, &peervault.ListFilesRequest{Page: page, PageSize: pageSize, Filter: filter})
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		for _, f := range resp.Files {
+			files = append(files, *fileFromProto(f))
+		}
+		if len(resp.Files) < pageSize || len(files) >= int(resp.Total) {
+			return files, nil
+		}
+	}
+}
+
+// Delete removes the file stored under key
+func (g *GRPCClient) Delete(ctx context.Context, key string) error {
+	return g.DeleteIf(ctx, key, Condition{})
+}
+
+// DeleteIf removes the file stored under key when it meets cond, failing
+// with ErrPrecondition otherwise
+func (g *GRPCClient) DeleteIf(ctx context.Context, key string, cond Condition) error {
+	_, err := g.raw.DeleteFile(ctx, &peervault.FileRequest{Key: key, IfMatch: cond.IfMatch, IfNoneMatch: cond.IfNoneMatch})
+	return grpcError(err)
+}
+
+// Peers returns the peers known to the server
+func (g *GRPCClient) Peers(ctx context.Context) ([]Peer, error) {
+	resp, err := g.raw.ListPeers(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	peers := make([]Peer, 0, len(resp.Peers))
+	for _, p := range resp.Peers {
+		peers = append(peers, *peerFromProto(p))
+	}
+	return peers, nil
+}
+
+// AddPeer registers the node at address and port as a peer
+func (g *GRPCClient) AddPeer(ctx context.Context, address string, port int, metadata map[string]string) (*Peer, error) {
+	resp, err := g.raw.AddPeer(ctx, &peervault.AddPeerRequest{Address: address, Port: int32(port), Metadata: metadata})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return peerFromProto(resp), nil
+}
+
+// RemovePeer removes the peer with the given ID
+func (g *GRPCClient) RemovePeer(ctx context.Context, id string) error {
+	_, err := g.raw.RemovePeer(ctx, &peervault.PeerRequest{Id: id})
+	return grpcError(err)
+}
+
+// Watch streams the server's file operation events until ctx is
+// cancelled. Event types are passed on as the server names them; File only
+// has the key and the event metadata filled in.
+func (g *GRPCClient) Watch(ctx context.Context) (*Watcher, error) {
+	stream, err := g.raw.StreamFileOperations(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	w := &Watcher{events: make(chan Event, 16)}
+	go func() {
+		defer close(w.events)
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				if err != io.EOF && ctx.Err() == nil {
+					w.mu.Lock()
+					w.err = grpcError(err)
+					w.mu.Unlock()
+				}
+				return
+			}
+			file := File{Key: event.FileKey, Metadata: event.Metadata, UpdatedAt: timeOf(event.Timestamp)}
+			select {
+			case w.events <- Event{Type: EventType(event.EventType), File: file}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return w, nil
+}
+
+// WatchPrefix streams the changes to the keys under prefix until ctx is
+// cancelled, starting after the event carrying token, or from now on when
+// token is empty. File only has the key, size and time filled in. When the
+// watch ends with a ResourceExhausted status the client fell behind and
+// watches again with the token of the last event; FailedPrecondition means
+// the server no longer holds the token, so the client lists the files and
+// watches from now on.
+func (g *GRPCClient) WatchPrefix(ctx context.Context, prefix, token string) (*Watcher, error) {
+	stream, err := g.raw.Watch(ctx, &peervault.WatchRequest{Prefix: prefix, Token: token})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	// The server sends the watch-token header once the watch started, and
+	// only a status when the token is refused
+	md, err := stream.Header()
+	if err == nil && len(md.Get("watch-token")) == 0 {
+		_, err = stream.Recv()
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	w := &Watcher{events: make(chan Event, 16)}
+	go func() {
+		defer close(w.events)
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				if err != io.EOF && ctx.Err() == nil {
+					w.mu.Lock()
+					w.err = grpcError(err)
+					w.mu.Unlock()
+				}
+				return
+			}
+			file := File{Key: event.Key, Size: event.Size, UpdatedAt: timeOf(event.Timestamp)}
+			select {
+			case w.events <- Event{Type: EventType(event.Op), File: file, Token: event.Token}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return w, nil
+}
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	grpcapi "github.com/Skpow1234/Peervault/internal/api/grpc"
+	"github.com/Skpow1234/Peervault/proto/peervault"
+)
+
+func newGRPCServer(t *testing.T, opts ...grpc.ServerOption) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer(append(opts, grpc.ForceServerCodec(GRPCCodec))...)
+	peervault.RegisterPeerVaultServiceServer(server, grpcapi.NewPeerVaultServiceImpl(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+func TestGRPCStoreDownload(t *testing.T) {
+	var auth atomic.Value
+	target := newGRPCServer(t, grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		auth.Store(strings.Join(md.Get("authorization"), ","))
+		return handler(srv, ss)
+	}))
+	c, err := DialGRPC(target, WithToken("secret"))
+	require.NoError(t, err)
+	defer func() { _ = c.Close() }()
+	ctx := context.Background()
+
+	data := bytes.Repeat([]byte("peervault"), 20000)
+	file, err := c.Store(ctx, "blob", bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), file.Size)
+	assert.Equal(t, "Bearer secret", auth.Load())
+
+	var buf bytes.Buffer
+	n, err := c.Download(ctx, "blob", &buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.True(t, bytes.Equal(data, buf.Bytes()))
+
+	body, err := c.Get(ctx, "blob")
+	require.NoError(t, err)
+	got, err := io.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	assert.Len(t, got, len(data))
+
+	stat, err := c.Stat(ctx, "blob")
+	require.NoError(t, err)
+	assert.Equal(t, file.Hash, stat.Hash)
+
+	require.NoError(t, c.Delete(ctx, "blob"))
+	_, err = c.Stat(ctx, "blob")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = c.Download(ctx, "blob", io.Discard)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestGRPCConditionalWrites(t *testing.T) {
+	c, err := DialGRPC(newGRPCServer(t))
+	require.NoError(t, err)
+	defer func() { _ = c.Close() }()
+	ctx := context.Background()
+
+	v1, err := c.StoreIf(ctx, "notes", strings.NewReader("v1"), Condition{IfNoneMatch: []string{"*"}})
+	require.NoError(t, err)
+	_, err = c.StoreIf(ctx, "notes", strings.NewReader("again"), Condition{IfNoneMatch: []string{"*"}})
+	assert.ErrorIs(t, err, ErrPrecondition)
+
+	// A writer holding a stale hash does not overwrite a newer version
+	v2, err := c.StoreIf(ctx, "notes", strings.NewReader("v2"), Condition{IfMatch: []string{v1.Hash}})
+	require.NoError(t, err)
+	_, err = c.StoreIf(ctx, "notes", strings.NewReader("lost update"), Condition{IfMatch: []string{v1.Hash}})
+	assert.ErrorIs(t, err, ErrPrecondition)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// Reads of an unchanged file fail, telling the client its copy is current
+	_, err = c.Raw().GetFile(ctx, &peervault.FileRequest{Key: "notes", IfNoneMatch: []string{v2.Hash}})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	resp, err := c.Raw().GetFile(ctx, &peervault.FileRequest{Key: "notes", IfNoneMatch: []string{v1.Hash}})
+	require.NoError(t, err)
+	assert.Equal(t, v2.Hash, resp.Hash)
+
+	stream, err := c.Raw().DownloadFile(ctx, &peervault.FileRequest{Key: "notes"})
+	require.NoError(t, err)
+	header, err := stream.Header()
+	require.NoError(t, err)
+	assert.Equal(t, []string{v2.Hash}, header.Get("etag"))
+
+	assert.ErrorIs(t, c.DeleteIf(ctx, "notes", Condition{IfMatch: []string{v1.Hash}}), ErrPrecondition)
+	require.NoError(t, c.DeleteIf(ctx, "notes", Condition{IfMatch: []string{v2.Hash}}))
+	_, err = c.Stat(ctx, "notes")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestGRPCListAndPeers(t *testing.T) {
+	c, err := DialGRPC(newGRPCServer(t))
+	require.NoError(t, err)
+	defer func() { _ = c.Close() }()
+	ctx := context.Background()
+
+	files, err := c.List(ctx, "")
+	require.NoError(t, err)
+	assert.NotEmpty(t, files)
+
+	peer, err := c.AddPeer(ctx, "10.0.0.3", 3000, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.3", peer.Address)
+	peers, err := c.Peers(ctx)
+	require.NoError(t, err)
+	assert.NotEmpty(t, peers)
+}
+
+func TestGRPCWatchPrefix(t *testing.T) {
+	c, err := DialGRPC(newGRPCServer(t))
+	require.NoError(t, err)
+	defer func() { _ = c.Close() }()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := c.WatchPrefix(ctx, "docs/", "")
+	require.NoError(t, err)
+	_, err = c.Store(ctx, "photos/a.jpg", strings.NewReader("a"))
+	require.NoError(t, err)
+	_, err = c.Store(ctx, "docs/b.txt", strings.NewReader("bb"))
+	require.NoError(t, err)
+	require.NoError(t, c.Delete(ctx, "docs/b.txt"))
+
+	created := <-w.Events()
+	assert.Equal(t, EventCreated, created.Type)
+	assert.Equal(t, "docs/b.txt", created.File.Key)
+	assert.Equal(t, int64(2), created.File.Size)
+	assert.Equal(t, EventDeleted, (<-w.Events()).Type)
+
+	// Resuming after the first event delivers the deletion again
+	resumed, err := c.WatchPrefix(ctx, "docs/", created.Token)
+	require.NoError(t, err)
+	assert.Equal(t, EventDeleted, (<-resumed.Events()).Type)
+
+	_, err = c.WatchPrefix(ctx, "", "garbage")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCBatchExecute(t *testing.T) {
+	c, err := DialGRPC(newGRPCServer(t))
+	require.NoError(t, err)
+	defer func() { _ = c.Close() }()
+	ctx := context.Background()
+
+	resp, err := c.Raw().BatchExecute(ctx, &peervault.BatchRequest{Parallelism: 1, Operations: []*peervault.BatchOperation{
+		{Op: "store", Key: "a.txt", Content: []byte("hello")},
+		{Op: "copy", From: "a.txt", To: "b.txt"},
+		{Op: "copy", From: "a.txt", To: "b.txt"},
+		{Op: "delete", Key: "a.txt"},
+		{Op: "tag", Key: "a.txt", Set: map[string]string{"owner": "ops"}},
+	}})
+	require.NoError(t, err)
+	var got []string
+	for _, r := range resp.Results {
+		got = append(got, r.Code)
+	}
+	assert.Equal(t, []string{"OK", "OK", "AlreadyExists", "OK", "NotFound"}, got)
+	assert.Equal(t, int32(3), resp.Succeeded)
+	assert.Equal(t, int32(2), resp.Failed)
+	assert.Equal(t, int64(5), resp.Results[1].File.Size)
+
+	stat, err := c.Stat(ctx, "b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), stat.Size)
+
+	_, err = c.Raw().BatchExecute(ctx, &peervault.BatchRequest{Operations: []*peervault.BatchOperation{{Op: "store"}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
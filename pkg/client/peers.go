@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+// Peer is a node the server replicates to
+type Peer struct {
+	ID        string            `json:"id"`
+	Address   string            `json:"address"`
+	Port      int               `json:"port"`
+	Status    string            `json:"status"`
+	LastSeen  time.Time         `json:"last_seen"`
+	CreatedAt time.Time         `json:"created_at"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// Peers returns the peers known to the server
+func (c *Client) Peers(ctx context.Context) ([]Peer, error) {
+	resp, err := c.do(ctx, &request{method: "GET", path: "/api/v1/peers", idempotent: true})
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Peers []Peer `json:"peers"`
+	}
+	if err := decode(resp, &list); err != nil {
+		return nil, err
+	}
+	return list.Peers, nil
+}
+
+// Peer returns the peer with the given ID
+func (c *Client) Peer(ctx context.Context, id string) (*Peer, error) {
+	resp, err := c.do(ctx, &request{method: "GET", path: "/api/v1/peers/get", query: url.Values{"id": {id}}, idempotent: true})
+	if err != nil {
+		return nil, err
+	}
+	var peer Peer
+	if err := decode(resp, &peer); err != nil {
+		return nil, err
+	}
+	return &peer, nil
+}
+
+// AddPeer registers the node at address and port as a peer
+func (c *Client) AddPeer(ctx context.Context, address string, port int, metadata map[string]string) (*Peer, error) {
+	body, err := jsonBody(map[string]interface{}{"address": address, "port": port, "metadata": metadata})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, &request{method: "POST", path: "/api/v1/peers", body: body, contentType: "application/json"})
+	if err != nil {
+		return nil, err
+	}
+	var peer Peer
+	if err := decode(resp, &peer); err != nil {
+		return nil, err
+	}
+	return &peer, nil
+}
+
+// RemovePeer removes the peer with the given ID
+func (c *Client) RemovePeer(ctx context.Context, id string) error {
+	resp, err := c.do(ctx, &request{method: "DELETE", path: "/api/v1/peers", query: url.Values{"id": {id}}, idempotent: true})
+	if err != nil {
+		return err
+	}
+	return decode(resp, nil)
+}
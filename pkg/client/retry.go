@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy controls how requests that failed for transient reasons are
+// retried. Requests are only repeated when that is safe: reads and other
+// idempotent calls after network errors and 5xx responses, any call the
+// server turned away with 429 or 503 before doing anything.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts; 1 disables retries
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry; it doubles for
+	// every further one
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy makes up to 3 attempts, waiting about 200ms and then
+// 400ms in between
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 3, InitialBackoff: 200 * time.Millisecond, MaxBackoff: 5 * time.Second}
+}
+
+// NoRetry makes a single attempt
+func NoRetry() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 1}
+}
+
+func (p RetryPolicy) attempts() int {
+	return max(1, p.MaxAttempts)
+}
+
+// backoff returns the jittered wait before the given retry, which counts
+// from 1
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < retry && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	// Anywhere between half and all of it, so clients that failed together
+	// do not retry together
+	return d/2 + rand.N(d/2+1)
+}
+
+func (p RetryPolicy) wait(ctx context.Context, retry int) error {
+	timer := time.NewTimer(p.backoff(retry))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryable reports whether a request answered with status may be sent
+// again
+func retryable(status int, idempotent bool) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
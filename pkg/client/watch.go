@@ -0,0 +1,142 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultWatchInterval is how often Watch polls the server
+const DefaultWatchInterval = 5 * time.Second
+
+// EventType is the kind of change a watch event reports
+type EventType string
+
+const (
+	EventCreated EventType = "created"
+	EventUpdated EventType = "updated"
+	EventDeleted EventType = "deleted"
+)
+
+// Event is a change to a watched file. For deletions File is the last
+// description seen.
+type Event struct {
+	Type EventType
+	File File
+	// Token resumes a GRPCClient.WatchPrefix after this event; polled
+	// events have none
+	Token string
+}
+
+// WatchOptions selects the files to watch and how often to look
+type WatchOptions struct {
+	ListOptions
+	// Interval between polls; defaults to DefaultWatchInterval
+	Interval time.Duration
+}
+
+// Watcher delivers the changes to the files matched by a watch
+type Watcher struct {
+	events chan Event
+	mu     sync.Mutex
+	err    error
+}
+
+// Events is closed when the watch ends, either because its context was
+// cancelled or because the server could not be reached even after retries
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Err tells why the watch ended once Events is closed; it is nil when the
+// context was cancelled
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Watch reports files matching opts as they are created, updated and
+// deleted, until ctx is cancelled. The REST API has no event stream, so
+// the watcher compares successive listings; changes that come and go
+// between two polls are not seen.
+func (c *Client) Watch(ctx context.Context, opts *WatchOptions) (*Watcher, error) {
+	if opts == nil {
+		opts = &WatchOptions{}
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	files, err := c.List(ctx, &opts.ListOptions)
+	if err != nil {
+		return nil, err
+	}
+	seen := indexFiles(files)
+
+	w := &Watcher{events: make(chan Event, 16)}
+	go func() {
+		defer close(w.events)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			files, err := c.List(ctx, &opts.ListOptions)
+			if err != nil {
+				if !errors.Is(err, ctx.Err()) {
+					w.mu.Lock()
+					w.err = err
+					w.mu.Unlock()
+				}
+				return
+			}
+			current := indexFiles(files)
+			for _, event := range diffFiles(seen, current) {
+				select {
+				case w.events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			seen = current
+		}
+	}()
+	return w, nil
+}
+
+func indexFiles(files []File) map[string]File {
+	index := make(map[string]File, len(files))
+	for _, f := range files {
+		index[f.Key] = f
+	}
+	return index
+}
+
+// diffFiles returns the events turning before into after, ordered by key
+func diffFiles(before, after map[string]File) []Event {
+	var events []Event
+	for key, f := range after {
+		old, ok := before[key]
+		switch {
+		case !ok:
+			events = append(events, Event{Type: EventCreated, File: f})
+		case old.Hash != f.Hash || !old.UpdatedAt.Equal(f.UpdatedAt):
+			events = append(events, Event{Type: EventUpdated, File: f})
+		}
+	}
+	for key, f := range before {
+		if _, ok := after[key]; !ok {
+			events = append(events, Event{Type: EventDeleted, File: f})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].File.Key < events[j].File.Key })
+	return events
+}
@@ -0,0 +1,443 @@
+// Package e2e encrypts files on the client before they are uploaded, with
+// a key or passphrase only the user holds, so the nodes storing them never
+// see the plaintext. The scheme and key ID are recorded in the metadata of
+// the file, which lets a download ask for the right key.
+//
+// An encrypted file starts with a header followed by the plaintext sealed
+// with AES-256-GCM in segments of SegmentSize bytes:
+//
+//	[magic "PVE1"][kdf:u8][logN:u8][r:u8][p:u8][salt:16][nonce:8][check:8]
+//	[segment 0 + tag][segment 1 + tag]...
+//
+// The nonce of a segment is the header nonce followed by the segment index,
+// and the last segment is sealed with different additional data, so
+// segments cannot be reordered or the file truncated unnoticed. As every
+// segment is sealed on its own, any byte range of the ciphertext can be
+// produced without the ones before it, which chunked uploads rely on.
+package e2e
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// MetaScheme is the metadata key naming the scheme a file is encrypted
+	// with, SchemeKey or SchemePassphrase
+	MetaScheme = "pv-encryption"
+	// MetaKeyID is the metadata key holding the ID of the key a file is
+	// encrypted with; files encrypted with a passphrase have none
+	MetaKeyID = "pv-encryption-key"
+
+	// SchemeKey encrypts with a 256-bit key
+	SchemeKey = "aes-256-gcm"
+	// SchemePassphrase encrypts with a key derived from a passphrase with
+	// scrypt
+	SchemePassphrase = "scrypt+aes-256-gcm"
+
+	// KeySize is the size of raw keys
+	KeySize = 32
+	// SegmentSize is the size of the plaintext sealed at a time
+	SegmentSize = 64 << 10
+	// HeaderSize is the size of the header of encrypted files
+	HeaderSize = 40
+
+	magic    = "PVE1"
+	tagSize  = 16
+	saltSize = 16
+
+	kdfNone   = 1
+	kdfScrypt = 2
+	// scrypt parameters: N = 2^15, r = 8, p = 1
+	scryptLogN = 15
+	scryptR    = 8
+	scryptP    = 1
+)
+
+var (
+	// ErrWrongKey is returned when a file was encrypted with another key or
+	// passphrase
+	ErrWrongKey = errors.New("e2e: wrong key or passphrase")
+	// ErrCorrupt is returned for encrypted content that fails to
+	// authenticate
+	ErrCorrupt = errors.New("e2e: encrypted content is corrupt or truncated")
+	// ErrNotEncrypted is returned for content without the header of an
+	// encrypted file
+	ErrNotEncrypted = errors.New("e2e: content is not encrypted")
+)
+
+// Key is a key or passphrase files are encrypted with
+type Key struct {
+	raw        []byte
+	passphrase string
+}
+
+// NewKey returns a key of KeySize random bytes
+func NewKey(raw []byte) (*Key, error) {
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("e2e: key must be %d bytes, got %d", KeySize, len(raw))
+	}
+	return &Key{raw: append([]byte(nil), raw...)}, nil
+}
+
+// GenerateKey returns a new random key
+func GenerateKey() (*Key, error) {
+	raw := make([]byte, KeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("e2e: %w", err)
+	}
+	return &Key{raw: raw}, nil
+}
+
+// ParseKey parses a key written by Key.String, in base64 or hex, as found
+// in key files
+func ParseKey(s string) (*Key, error) {
+	s = strings.TrimSpace(s)
+	if raw, err := base64.StdEncoding.DecodeString(s); err == nil && len(raw) == KeySize {
+		return NewKey(raw)
+	}
+	if raw, err := hex.DecodeString(s); err == nil && len(raw) == KeySize {
+		return NewKey(raw)
+	}
+	return nil, fmt.Errorf("e2e: a key is %d bytes in base64 or hex", KeySize)
+}
+
+// NewPassphrase returns a key deriving the key of each file from
+// passphrase and a random salt
+func NewPassphrase(passphrase string) (*Key, error) {
+	if passphrase == "" {
+		return nil, errors.New("e2e: empty passphrase")
+	}
+	return &Key{passphrase: passphrase}, nil
+}
+
+// String returns a raw key in base64, and nothing for a passphrase
+func (k *Key) String() string {
+	if k.raw == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(k.raw)
+}
+
+// Scheme returns the scheme files are encrypted with
+func (k *Key) Scheme() string {
+	if k.raw == nil {
+		return SchemePassphrase
+	}
+	return SchemeKey
+}
+
+// ID identifies a raw key without revealing it; passphrases have none
+func (k *Key) ID() string {
+	if k.raw == nil {
+		return ""
+	}
+	sum := sha256.Sum256(append([]byte("peervault e2e key id\x00"), k.raw...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Metadata returns the metadata recording the scheme of files encrypted
+// with k
+func (k *Key) Metadata() map[string]string {
+	meta := map[string]string{MetaScheme: k.Scheme()}
+	if id := k.ID(); id != "" {
+		meta[MetaKeyID] = id
+	}
+	return meta
+}
+
+// Encrypted returns the scheme and key ID recorded in the metadata of an
+// encrypted file, and false for files stored in plaintext
+func Encrypted(metadata map[string]string) (scheme, keyID string, ok bool) {
+	scheme, ok = metadata[MetaScheme]
+	return scheme, metadata[MetaKeyID], ok
+}
+
+// Check tells whether k can decrypt a file with metadata, before its
+// content is fetched
+func (k *Key) Check(metadata map[string]string) error {
+	scheme, keyID, ok := Encrypted(metadata)
+	switch {
+	case !ok:
+		return ErrNotEncrypted
+	case scheme != SchemeKey && scheme != SchemePassphrase:
+		return fmt.Errorf("e2e: unsupported scheme %q", scheme)
+	case scheme != k.Scheme() && scheme == SchemePassphrase:
+		return fmt.Errorf("%w: the file is encrypted with a passphrase", ErrWrongKey)
+	case scheme != k.Scheme():
+		return fmt.Errorf("%w: the file is encrypted with key %s", ErrWrongKey, keyID)
+	case keyID != "" && keyID != k.ID():
+		return fmt.Errorf("%w: the file is encrypted with key %s, not %s", ErrWrongKey, keyID, k.ID())
+	}
+	return nil
+}
+
+// EncryptedSize returns the size of size bytes of plaintext once encrypted
+func EncryptedSize(size int64) int64 {
+	segments := max(1, (size+SegmentSize-1)/SegmentSize)
+	return HeaderSize + size + segments*tagSize
+}
+
+// Encrypter seals one file; its header holds the salt and nonce, so an
+// upload resumed with the same header produces the same ciphertext
+type Encrypter struct {
+	header []byte
+	aead   cipher.AEAD
+}
+
+// NewEncrypter starts a file with a new salt and nonce
+func (k *Key) NewEncrypter() (*Encrypter, error) {
+	header := make([]byte, HeaderSize)
+	copy(header, magic)
+	header[4], header[5], header[6], header[7] = kdfNone, 0, 0, 0
+	if k.raw == nil {
+		header[4], header[5], header[6], header[7] = kdfScrypt, scryptLogN, scryptR, scryptP
+	}
+	if _, err := rand.Read(header[8:32]); err != nil {
+		return nil, fmt.Errorf("e2e: %w", err)
+	}
+	fileKey, err := k.fileKey(header)
+	if err != nil {
+		return nil, err
+	}
+	copy(header[32:], check(fileKey, header))
+	return newEncrypter(header, fileKey)
+}
+
+// ResumeEncrypter continues a file started with header, failing with
+// ErrWrongKey if k did not write it
+func (k *Key) ResumeEncrypter(header []byte) (*Encrypter, error) {
+	fileKey, err := k.open(header)
+	if err != nil {
+		return nil, err
+	}
+	return newEncrypter(append([]byte(nil), header[:HeaderSize]...), fileKey)
+}
+
+func newEncrypter(header, fileKey []byte) (*Encrypter, error) {
+	aead, err := newAEAD(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Encrypter{header: header, aead: aead}, nil
+}
+
+// Header returns the header of the file
+func (e *Encrypter) Header() []byte {
+	return append([]byte(nil), e.header...)
+}
+
+// Encrypt writes the encryption of src to dst and returns the number of
+// bytes written
+func (e *Encrypter) Encrypt(dst io.Writer, src io.Reader) (int64, error) {
+	n, err := dst.Write(e.header)
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+	// One byte more than a segment tells whether another one follows
+	r := bufio.NewReaderSize(src, SegmentSize+1)
+	plain := make([]byte, SegmentSize)
+	sealed := make([]byte, 0, SegmentSize+tagSize)
+	for index := uint32(0); ; index++ {
+		n, err := io.ReadFull(r, plain)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return written, err
+		}
+		last := n < SegmentSize
+		if !last {
+			if _, err := r.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return written, err
+			}
+		}
+		sealed = e.seal(sealed[:0], index, plain[:n], last)
+		m, err := dst.Write(sealed)
+		written += int64(m)
+		if err != nil || last {
+			return written, err
+		}
+	}
+}
+
+func (e *Encrypter) seal(dst []byte, index uint32, plain []byte, last bool) []byte {
+	return e.aead.Seal(dst, nonce(e.header, index), plain, segmentAD(last))
+}
+
+// ReaderAt returns the encryption of the size bytes of plaintext in r as
+// an io.ReaderAt, producing only the segments a read covers
+func (e *Encrypter) ReaderAt(r io.ReaderAt, size int64) io.ReaderAt {
+	return &encryptedReaderAt{e: e, r: r, size: size}
+}
+
+type encryptedReaderAt struct {
+	e    *Encrypter
+	r    io.ReaderAt
+	size int64
+}
+
+func (c *encryptedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	total := EncryptedSize(c.size)
+	if off < 0 {
+		return 0, errors.New("e2e: negative offset")
+	}
+	n := 0
+	for len(p) > 0 && off < total {
+		if off < HeaderSize {
+			m := copy(p, c.e.header[off:])
+			n, off, p = n+m, off+int64(m), p[m:]
+			continue
+		}
+		index := (off - HeaderSize) / (SegmentSize + tagSize)
+		start := index * SegmentSize
+		length := min(int64(SegmentSize), c.size-start)
+		plain := make([]byte, length)
+		if m, err := c.r.ReadAt(plain, start); m < len(plain) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+		last := start+length >= c.size
+		sealed := c.e.seal(nil, uint32(index), plain, last)
+		m := copy(p, sealed[off-HeaderSize-index*(SegmentSize+tagSize):])
+		n, off, p = n+m, off+int64(m), p[m:]
+	}
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Decrypt writes the plaintext of an encrypted file read from src to dst
+// and returns the number of bytes written. A failure after some plaintext
+// was written leaves dst incomplete; callers write to a temporary file.
+func (k *Key) Decrypt(dst io.Writer, src io.Reader) (int64, error) {
+	header := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, ErrNotEncrypted
+		}
+		return 0, err
+	}
+	fileKey, err := k.open(header)
+	if err != nil {
+		return 0, err
+	}
+	aead, err := newAEAD(fileKey)
+	if err != nil {
+		return 0, err
+	}
+
+	var written int64
+	r := bufio.NewReaderSize(src, SegmentSize+tagSize+1)
+	sealed := make([]byte, SegmentSize+tagSize)
+	plain := make([]byte, 0, SegmentSize)
+	for index := uint32(0); ; index++ {
+		n, err := io.ReadFull(r, sealed)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return written, err
+		}
+		last := n < len(sealed)
+		if !last {
+			if _, err := r.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return written, err
+			}
+		}
+		plain, err = aead.Open(plain[:0], nonce(header, index), sealed[:n], segmentAD(last))
+		if err != nil {
+			return written, ErrCorrupt
+		}
+		m, err := dst.Write(plain)
+		written += int64(m)
+		if err != nil || last {
+			return written, err
+		}
+	}
+}
+
+// open derives the key of the file with header, checking that k wrote it
+func (k *Key) open(header []byte) ([]byte, error) {
+	if len(header) < HeaderSize || string(header[:len(magic)]) != magic {
+		return nil, ErrNotEncrypted
+	}
+	switch {
+	case header[4] == kdfScrypt && k.raw != nil:
+		return nil, fmt.Errorf("%w: the file is encrypted with a passphrase", ErrWrongKey)
+	case header[4] == kdfNone && k.raw == nil:
+		return nil, fmt.Errorf("%w: the file is encrypted with a key", ErrWrongKey)
+	}
+	fileKey, err := k.fileKey(header)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(check(fileKey, header), header[32:HeaderSize]) {
+		return nil, ErrWrongKey
+	}
+	return fileKey, nil
+}
+
+// fileKey derives the key sealing the segments of the file with header
+func (k *Key) fileKey(header []byte) ([]byte, error) {
+	salt := header[8:24]
+	if k.raw == nil {
+		logN, r, p := header[5], int(header[6]), int(header[7])
+		if header[4] != kdfScrypt || logN < 10 || logN > 22 {
+			return nil, ErrCorrupt
+		}
+		key, err := scrypt.Key([]byte(k.passphrase), salt, 1<<logN, r, p, KeySize)
+		if err != nil {
+			return nil, fmt.Errorf("e2e: %w", err)
+		}
+		return key, nil
+	}
+	mac := hmac.New(sha256.New, k.raw)
+	mac.Write([]byte("peervault e2e file key\x00"))
+	mac.Write(salt)
+	return mac.Sum(nil), nil
+}
+
+// check returns the value in the header proving which key wrote it
+func check(fileKey, header []byte) []byte {
+	mac := hmac.New(sha256.New, fileKey)
+	mac.Write(header[:32])
+	return mac.Sum(nil)[:8]
+}
+
+func nonce(header []byte, index uint32) []byte {
+	n := make([]byte, 12)
+	copy(n, header[24:32])
+	binary.BigEndian.PutUint32(n[8:], index)
+	return n
+}
+
+func segmentAD(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("e2e: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
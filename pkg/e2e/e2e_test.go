@@ -0,0 +1,134 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encrypt(t *testing.T, k *Key, plain []byte) []byte {
+	t.Helper()
+	enc, err := k.NewEncrypter()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	n, err := enc.Encrypt(&buf, bytes.NewReader(plain))
+	require.NoError(t, err)
+	assert.Equal(t, EncryptedSize(int64(len(plain))), n)
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	passphrase, err := NewPassphrase("correct horse battery staple")
+	require.NoError(t, err)
+
+	for _, size := range []int{0, 1, SegmentSize - 1, SegmentSize, 2*SegmentSize + 7} {
+		plain := make([]byte, size)
+		_, err := rand.Read(plain)
+		require.NoError(t, err)
+		for _, k := range []*Key{key, passphrase} {
+			sealed := encrypt(t, k, plain)
+			assert.False(t, bytes.Contains(sealed, plain) && size > 0)
+
+			var out bytes.Buffer
+			n, err := k.Decrypt(&out, bytes.NewReader(sealed))
+			require.NoError(t, err, size)
+			assert.Equal(t, int64(size), n)
+			assert.True(t, bytes.Equal(plain, out.Bytes()), size)
+		}
+	}
+}
+
+func TestReaderAtMatchesEncrypt(t *testing.T) {
+	k, err := GenerateKey()
+	require.NoError(t, err)
+	plain := make([]byte, 3*SegmentSize+100)
+	_, err = rand.Read(plain)
+	require.NoError(t, err)
+
+	enc, err := k.NewEncrypter()
+	require.NoError(t, err)
+	var whole bytes.Buffer
+	_, err = enc.Encrypt(&whole, bytes.NewReader(plain))
+	require.NoError(t, err)
+
+	// An upload resumed from the header yields the same bytes, read in
+	// parts that straddle segments
+	resumed, err := k.ResumeEncrypter(enc.Header())
+	require.NoError(t, err)
+	ra := resumed.ReaderAt(bytes.NewReader(plain), int64(len(plain)))
+	var parts bytes.Buffer
+	_, err = io.Copy(&parts, io.NewSectionReader(ra, 0, EncryptedSize(int64(len(plain)))))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(whole.Bytes(), parts.Bytes()))
+
+	part := make([]byte, 1000)
+	n, err := ra.ReadAt(part, SegmentSize-10)
+	require.NoError(t, err)
+	assert.Equal(t, whole.Bytes()[SegmentSize-10:SegmentSize+990], part[:n])
+}
+
+func TestWrongKey(t *testing.T) {
+	k, err := GenerateKey()
+	require.NoError(t, err)
+	other, err := GenerateKey()
+	require.NoError(t, err)
+	passphrase, err := NewPassphrase("one")
+	require.NoError(t, err)
+	otherPassphrase, err := NewPassphrase("two")
+	require.NoError(t, err)
+
+	sealed := encrypt(t, k, []byte("secret"))
+	_, err = other.Decrypt(io.Discard, bytes.NewReader(sealed))
+	assert.ErrorIs(t, err, ErrWrongKey)
+	_, err = passphrase.Decrypt(io.Discard, bytes.NewReader(sealed))
+	assert.ErrorIs(t, err, ErrWrongKey)
+
+	sealed = encrypt(t, passphrase, []byte("secret"))
+	_, err = otherPassphrase.Decrypt(io.Discard, bytes.NewReader(sealed))
+	assert.ErrorIs(t, err, ErrWrongKey)
+	_, err = otherPassphrase.ResumeEncrypter(sealed[:HeaderSize])
+	assert.ErrorIs(t, err, ErrWrongKey)
+
+	// Metadata tells before anything is fetched
+	assert.NoError(t, k.Check(k.Metadata()))
+	assert.ErrorIs(t, other.Check(k.Metadata()), ErrWrongKey)
+	assert.ErrorIs(t, k.Check(passphrase.Metadata()), ErrWrongKey)
+	assert.NoError(t, otherPassphrase.Check(passphrase.Metadata()))
+	assert.ErrorIs(t, k.Check(map[string]string{"team": "infra"}), ErrNotEncrypted)
+}
+
+func TestTampering(t *testing.T) {
+	k, err := GenerateKey()
+	require.NoError(t, err)
+	sealed := encrypt(t, k, make([]byte, 2*SegmentSize+5))
+
+	flipped := bytes.Clone(sealed)
+	flipped[HeaderSize+10] ^= 1
+	_, err = k.Decrypt(io.Discard, bytes.NewReader(flipped))
+	assert.ErrorIs(t, err, ErrCorrupt)
+
+	// Dropping the last segment leaves a segment not sealed as the last
+	truncated := sealed[:HeaderSize+2*(SegmentSize+tagSize)]
+	_, err = k.Decrypt(io.Discard, bytes.NewReader(truncated))
+	assert.ErrorIs(t, err, ErrCorrupt)
+
+	_, err = k.Decrypt(io.Discard, bytes.NewReader([]byte("plain text that is long enough for a header")))
+	assert.ErrorIs(t, err, ErrNotEncrypted)
+}
+
+func TestParseKey(t *testing.T) {
+	k, err := GenerateKey()
+	require.NoError(t, err)
+	parsed, err := ParseKey(k.String() + "\n")
+	require.NoError(t, err)
+	assert.Equal(t, k.ID(), parsed.ID())
+
+	_, err = ParseKey("too short")
+	assert.Error(t, err)
+}
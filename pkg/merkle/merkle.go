@@ -0,0 +1,202 @@
+// Package merkle describes a file as a Merkle tree over its fixed-size
+// chunks. The same tree verifies a download chunk by chunk, checks a byte
+// range without the rest of the file, and tells which chunks two copies
+// differ in, so only those need to be transferred.
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+const (
+	// Algorithm hashes the chunks and the nodes of the tree
+	Algorithm = "sha256"
+	// DefaultChunkSize is the chunk size of the root recorded for files
+	DefaultChunkSize = 1 << 20
+	// MinChunkSize and MaxChunkSize bound the chunk size of a manifest
+	MinChunkSize = 1 << 10
+	MaxChunkSize = 64 << 20
+)
+
+// ErrMismatch reports content or a manifest that does not match the tree
+var ErrMismatch = errors.New("merkle: content does not match the tree")
+
+// Manifest is the tree of a file: the hashes of its chunks, in order, and
+// the root they hash up to
+type Manifest struct {
+	Algorithm string `json:"algorithm"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunk_size"`
+	Root      string `json:"root"`
+	// Chunks are the hex hashes of the chunks; the last one may be short
+	Chunks []string `json:"chunks"`
+}
+
+// Root hashes the leaves up to the root of a binary tree, promoting the
+// last node of odd-sized levels unchanged. The root of no leaves is the
+// hash of nothing.
+func Root(newHash func() hash.Hash, leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return newHash().Sum(nil)
+	}
+	level := leaves
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			h := newHash()
+			h.Write(level[i])
+			h.Write(level[i+1])
+			next = append(next, h.Sum(nil))
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// ValidChunkSize reports whether a manifest may use chunkSize
+func ValidChunkSize(chunkSize int64) bool {
+	return chunkSize >= MinChunkSize && chunkSize <= MaxChunkSize
+}
+
+// Builder computes the manifest of the content written to it
+type Builder struct {
+	chunkSize int64
+	buf       []byte
+	leaves    [][]byte
+	size      int64
+}
+
+// NewBuilder returns a Builder cutting content into chunks of chunkSize
+// bytes, DefaultChunkSize when it is not positive
+func NewBuilder(chunkSize int64) *Builder {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return &Builder{chunkSize: chunkSize, buf: make([]byte, 0, chunkSize)}
+}
+
+func (b *Builder) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := min(int(b.chunkSize)-len(b.buf), len(p))
+		b.buf = append(b.buf, p[:n]...)
+		p = p[n:]
+		if int64(len(b.buf)) == b.chunkSize {
+			b.flush()
+		}
+	}
+	return written, nil
+}
+
+func (b *Builder) flush() {
+	if len(b.buf) == 0 {
+		return
+	}
+	sum := sha256.Sum256(b.buf)
+	b.leaves = append(b.leaves, sum[:])
+	b.size += int64(len(b.buf))
+	b.buf = b.buf[:0]
+}
+
+// Manifest returns the manifest of the content written so far
+func (b *Builder) Manifest() *Manifest {
+	b.flush()
+	m := &Manifest{
+		Algorithm: Algorithm,
+		Size:      b.size,
+		ChunkSize: b.chunkSize,
+		Root:      hex.EncodeToString(Root(sha256.New, b.leaves)),
+		Chunks:    make([]string, len(b.leaves)),
+	}
+	for i, leaf := range b.leaves {
+		m.Chunks[i] = hex.EncodeToString(leaf)
+	}
+	return m
+}
+
+// Build returns the manifest of the content of r
+func Build(r io.Reader, chunkSize int64) (*Manifest, error) {
+	b := NewBuilder(chunkSize)
+	if _, err := io.Copy(b, r); err != nil {
+		return nil, err
+	}
+	return b.Manifest(), nil
+}
+
+// RootOf returns the root of data cut into chunks of DefaultChunkSize
+func RootOf(data []byte) string {
+	m, _ := Build(bytes.NewReader(data), DefaultChunkSize)
+	return m.Root
+}
+
+// Verify checks that the chunks cover the size and hash up to the root, so
+// the chunk hashes of a manifest whose root is trusted can be trusted too
+func (m *Manifest) Verify() error {
+	if m.Algorithm != Algorithm {
+		return fmt.Errorf("merkle: unsupported algorithm %q", m.Algorithm)
+	}
+	if m.ChunkSize <= 0 || m.Size < 0 {
+		return fmt.Errorf("%w: invalid size", ErrMismatch)
+	}
+	if want := (m.Size + m.ChunkSize - 1) / m.ChunkSize; int64(len(m.Chunks)) != want {
+		return fmt.Errorf("%w: %d chunks for %d bytes", ErrMismatch, len(m.Chunks), m.Size)
+	}
+	leaves := make([][]byte, len(m.Chunks))
+	for i, c := range m.Chunks {
+		leaf, err := hex.DecodeString(c)
+		if err != nil || len(leaf) != sha256.Size {
+			return fmt.Errorf("%w: invalid hash of chunk %d", ErrMismatch, i)
+		}
+		leaves[i] = leaf
+	}
+	if root := hex.EncodeToString(Root(sha256.New, leaves)); root != m.Root {
+		return fmt.Errorf("%w: chunks hash to %s, not %s", ErrMismatch, root, m.Root)
+	}
+	return nil
+}
+
+// Chunk returns the byte range of chunk i
+func (m *Manifest) Chunk(i int) (offset, length int64) {
+	offset = int64(i) * m.ChunkSize
+	return offset, min(m.ChunkSize, m.Size-offset)
+}
+
+// VerifyChunk checks data against the hash of chunk i
+func (m *Manifest) VerifyChunk(i int, data []byte) error {
+	sum := sha256.Sum256(data)
+	return m.VerifyChunkSum(i, sum[:])
+}
+
+// VerifyChunkSum checks the SHA-256 of chunk i, computed while it streamed
+func (m *Manifest) VerifyChunkSum(i int, sum []byte) error {
+	if i < 0 || i >= len(m.Chunks) {
+		return fmt.Errorf("%w: no chunk %d", ErrMismatch, i)
+	}
+	if got := hex.EncodeToString(sum); got != m.Chunks[i] {
+		return fmt.Errorf("%w: chunk %d hashes to %s, not %s", ErrMismatch, i, got, m.Chunks[i])
+	}
+	return nil
+}
+
+// Diff returns the chunks of m that other does not hold at the same
+// position: the chunks a copy described by other needs to become m. Every
+// chunk differs when the chunk sizes do.
+func (m *Manifest) Diff(other *Manifest) []int {
+	var changed []int
+	for i, c := range m.Chunks {
+		if other == nil || other.ChunkSize != m.ChunkSize || i >= len(other.Chunks) || other.Chunks[i] != c {
+			changed = append(changed, i)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,84 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild(t *testing.T) {
+	data := make([]byte, 3*MinChunkSize+10)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	m, err := Build(bytes.NewReader(data), MinChunkSize)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), m.Size)
+	assert.Len(t, m.Chunks, 4)
+	require.NoError(t, m.Verify())
+
+	// Writes of any size cut the same chunks
+	b := NewBuilder(MinChunkSize)
+	for off := 0; off < len(data); off += 333 {
+		_, _ = b.Write(data[off:min(off+333, len(data))])
+	}
+	assert.Equal(t, m, b.Manifest())
+
+	for i := range m.Chunks {
+		offset, length := m.Chunk(i)
+		assert.NoError(t, m.VerifyChunk(i, data[offset:offset+length]))
+	}
+	offset, length := m.Chunk(3)
+	assert.Equal(t, int64(10), length)
+	assert.ErrorIs(t, m.VerifyChunk(2, data[offset:offset+length]), ErrMismatch)
+
+	empty, err := Build(bytes.NewReader(nil), 0)
+	require.NoError(t, err)
+	sum := sha256.Sum256(nil)
+	assert.Equal(t, RootOf(nil), empty.Root)
+	assert.Equal(t, sum[:], Root(sha256.New, nil))
+	assert.NoError(t, empty.Verify())
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	m, err := Build(bytes.NewReader(make([]byte, 5*MinChunkSize)), MinChunkSize)
+	require.NoError(t, err)
+
+	swapped := *m
+	swapped.Chunks = append([]string{}, m.Chunks...)
+	swapped.Chunks[1] = RootOf([]byte("other"))
+	assert.ErrorIs(t, swapped.Verify(), ErrMismatch)
+
+	short := *m
+	short.Chunks = m.Chunks[:4]
+	assert.ErrorIs(t, short.Verify(), ErrMismatch)
+
+	grown := *m
+	grown.Size++
+	assert.ErrorIs(t, grown.Verify(), ErrMismatch)
+}
+
+func TestDiff(t *testing.T) {
+	old := make([]byte, 4*MinChunkSize)
+	_, err := rand.Read(old)
+	require.NoError(t, err)
+	updated := append(bytes.Clone(old), []byte("appended")...)
+	updated[MinChunkSize+5] ^= 1
+
+	oldManifest, err := Build(bytes.NewReader(old), MinChunkSize)
+	require.NoError(t, err)
+	newManifest, err := Build(bytes.NewReader(updated), MinChunkSize)
+	require.NoError(t, err)
+
+	assert.Equal(t, []int{1, 4}, newManifest.Diff(oldManifest))
+	assert.Empty(t, newManifest.Diff(newManifest))
+	assert.Len(t, newManifest.Diff(nil), 5)
+
+	other, err := Build(bytes.NewReader(updated), 2*MinChunkSize)
+	require.NoError(t, err)
+	assert.Len(t, newManifest.Diff(other), 5)
+}
@@ -0,0 +1,264 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTimeout bounds calls whose context has no deadline
+	DefaultTimeout = 30 * time.Second
+	// launchTimeout bounds the handshake of a starting plugin
+	launchTimeout = 10 * time.Second
+	// stopTimeout is how long a plugin has to exit after its stdin is
+	// closed before it is killed
+	stopTimeout = 5 * time.Second
+)
+
+// ErrExited is returned by calls to a plugin whose process has exited
+var ErrExited = errors.New("plugin: process exited")
+
+// Config launches a plugin
+type Config struct {
+	// Name identifies the plugin in errors; defaults to the base name of
+	// Path
+	Name string
+	// Path is the plugin executable; Args are passed to it and Env is
+	// added to the server's environment
+	Path string
+	Args []string
+	Env  []string
+	// Stderr receives what the plugin logs; defaults to os.Stderr
+	Stderr io.Writer
+	// Timeout bounds calls whose context has no deadline; defaults to
+	// DefaultTimeout
+	Timeout time.Duration
+}
+
+// Client is a launched plugin. Its capabilities implement the interfaces
+// of this package by calling the plugin.
+type Client struct {
+	cfg  Config
+	cmd  *exec.Cmd
+	rpc  *rpc.Client
+	info Info
+
+	exited chan struct{}
+	once   sync.Once
+}
+
+// Launch starts the plugin of cfg and checks that it speaks
+// ProtocolVersion
+func Launch(cfg Config) (*Client, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("plugin: path required")
+	}
+	if cfg.Name == "" {
+		cfg.Name = strings.TrimSuffix(filepath.Base(cfg.Path), filepath.Ext(cfg.Path))
+	}
+	if cfg.Stderr == nil {
+		cfg.Stderr = os.Stderr
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+
+	// The pipes are made here rather than with StdinPipe and StdoutPipe,
+	// which Wait closes while the connection may still be reading
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return nil, err
+	}
+	cmd := exec.Command(cfg.Path, cfg.Args...)
+	cmd.Env = append(append(os.Environ(), cfg.Env...), CookieKey+"="+CookieValue)
+	cmd.Stdin = stdinR
+	cmd.Stdout = stdoutW
+	cmd.Stderr = cfg.Stderr
+	err = cmd.Start()
+	stdinR.Close()
+	stdoutW.Close()
+	if err != nil {
+		stdinW.Close()
+		stdoutR.Close()
+		return nil, fmt.Errorf("plugin %s: %w", cfg.Name, err)
+	}
+
+	c := &Client{
+		cfg:    cfg,
+		cmd:    cmd,
+		rpc:    rpc.NewClient(&pipeConn{r: stdoutR, w: stdinW}),
+		exited: make(chan struct{}),
+	}
+	go func() {
+		_ = cmd.Wait()
+		close(c.exited)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), launchTimeout)
+	defer cancel()
+	var resp Response
+	if err := c.call(ctx, "Info", Request{}, &resp); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if resp.Info.Protocol != ProtocolVersion {
+		c.Close()
+		return nil, fmt.Errorf("plugin %s: speaks protocol %d, want %d", cfg.Name, resp.Info.Protocol, ProtocolVersion)
+	}
+	c.info = resp.Info
+	return c, nil
+}
+
+// Name returns the name the plugin was launched with
+func (c *Client) Name() string { return c.cfg.Name }
+
+// Info returns what the plugin reported about itself on launch
+func (c *Client) Info() Info { return c.info }
+
+// Storage returns the plugin's storage backend, or nil when it provides
+// none
+func (c *Client) Storage() Storage {
+	if !c.info.Has(CapStorage) {
+		return nil
+	}
+	return remoteStorage{c}
+}
+
+// Auth returns the plugin's auth provider, or nil when it provides none
+func (c *Client) Auth() Auth {
+	if !c.info.Has(CapAuth) {
+		return nil
+	}
+	return remoteAuth{c}
+}
+
+// Scanner returns the plugin's content scanner, or nil when it provides
+// none
+func (c *Client) Scanner() Scanner {
+	if !c.info.Has(CapScanner) {
+		return nil
+	}
+	return remoteScanner{c}
+}
+
+// EventSink returns the plugin's event sink, or nil when it provides none
+func (c *Client) EventSink() EventSink {
+	if !c.info.Has(CapEvents) {
+		return nil
+	}
+	return remoteSink{c}
+}
+
+// Exited is closed when the plugin's process exits
+func (c *Client) Exited() <-chan struct{} { return c.exited }
+
+// Close stops the plugin: closing its stdin tells it to exit, and it is
+// killed if it has not within a few seconds
+func (c *Client) Close() error {
+	c.once.Do(func() {
+		c.rpc.Close()
+		select {
+		case <-c.exited:
+		case <-time.After(stopTimeout):
+			_ = c.cmd.Process.Kill()
+			<-c.exited
+		}
+	})
+	return nil
+}
+
+// call runs method on the plugin, giving up when ctx is done. Calls
+// without a deadline get the configured timeout.
+func (c *Client) call(ctx context.Context, method string, req Request, resp *Response) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+	req.Deadline, _ = ctx.Deadline()
+
+	call := c.rpc.Go(serviceName+"."+method, req, resp, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+	case <-ctx.Done():
+		return fmt.Errorf("plugin %s: %s: %w", c.cfg.Name, method, ctx.Err())
+	}
+	var serverErr rpc.ServerError
+	switch {
+	case call.Error == nil:
+		return nil
+	case errors.As(call.Error, &serverErr):
+		return fmt.Errorf("plugin %s: %w", c.cfg.Name, decodeError(string(serverErr)))
+	case errors.Is(call.Error, rpc.ErrShutdown) || errors.Is(call.Error, io.ErrUnexpectedEOF) || errors.Is(call.Error, io.EOF):
+		return fmt.Errorf("plugin %s: %w", c.cfg.Name, ErrExited)
+	default:
+		return fmt.Errorf("plugin %s: %w", c.cfg.Name, call.Error)
+	}
+}
+
+type remoteStorage struct{ c *Client }
+
+func (s remoteStorage) Put(ctx context.Context, key string, data []byte) error {
+	return s.c.call(ctx, "Put", Request{Key: key, Data: data}, &Response{})
+}
+
+func (s remoteStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	var resp Response
+	if err := s.c.call(ctx, "Get", Request{Key: key}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+func (s remoteStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	var resp Response
+	if err := s.c.call(ctx, "List", Request{Key: prefix}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Keys, nil
+}
+
+func (s remoteStorage) Delete(ctx context.Context, key string) error {
+	return s.c.call(ctx, "Delete", Request{Key: key}, &Response{})
+}
+
+type remoteAuth struct{ c *Client }
+
+func (a remoteAuth) Authenticate(ctx context.Context, creds Credentials) (*Identity, error) {
+	var resp Response
+	if err := a.c.call(ctx, "Authenticate", Request{Credentials: creds}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Identity, nil
+}
+
+type remoteScanner struct{ c *Client }
+
+func (s remoteScanner) Scan(ctx context.Context, key string, data []byte) (string, error) {
+	var resp Response
+	if err := s.c.call(ctx, "Scan", Request{Key: key, Data: data}, &resp); err != nil {
+		return "", err
+	}
+	return resp.Finding, nil
+}
+
+type remoteSink struct{ c *Client }
+
+func (s remoteSink) Handle(ctx context.Context, e Event) error {
+	return s.c.call(ctx, "Handle", Request{Event: e}, &Response{})
+}
@@ -0,0 +1,164 @@
+// Package plugin lets third parties extend PeerVault with executables the
+// server launches, without building against its internal packages. A
+// plugin provides any of four capabilities: a storage backend for
+// backups, an auth provider for API tokens and passwords, a content
+// scanner for stored files and an event sink for file changes.
+//
+// A plugin is a program whose main function hands its implementations to
+// Serve:
+//
+//	func main() {
+//		err := plugin.Serve(plugin.Plugins{
+//			Name:    "deny-list",
+//			Version: "1.0.0",
+//			Scanner: denyList{},
+//		})
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+//
+// The server starts it with Launch and talks to it over the plugin's
+// stdin and stdout with net/rpc, so a plugin needs no listening socket;
+// whatever it writes to stderr ends up in the server's log. Calls carry
+// the deadline of the server's context. The interfaces of this package
+// are the stable contract: new capabilities are added as new interfaces
+// and ProtocolVersion changes only when existing calls change.
+package plugin
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+)
+
+// ProtocolVersion is the version of the calls between the server and its
+// plugins. A plugin built against another version is refused on launch.
+const ProtocolVersion = 1
+
+const (
+	// CookieKey and CookieValue are set in the environment of launched
+	// plugins, which tells a plugin it was started by the server rather
+	// than from a shell
+	CookieKey   = "PEERVAULT_PLUGIN_COOKIE"
+	CookieValue = "d2b0c7e4-peervault-plugin"
+)
+
+// Capabilities a plugin reports in its Info
+const (
+	CapStorage = "storage"
+	CapAuth    = "auth"
+	CapScanner = "scanner"
+	CapEvents  = "events"
+)
+
+var (
+	// ErrNotFound is returned by storage backends for missing keys
+	ErrNotFound = errors.New("plugin: not found")
+	// ErrDenied is returned by auth providers for credentials they
+	// refuse, as opposed to failing to check them
+	ErrDenied = errors.New("plugin: access denied")
+	// ErrUnsupported is returned for calls of a capability the plugin
+	// does not provide
+	ErrUnsupported = errors.New("plugin: capability not provided")
+	// ErrNotLaunched is returned by Serve when the program was not started
+	// by the server
+	ErrNotLaunched = errors.New("plugin: this program is a PeerVault plugin and is launched by the server")
+)
+
+// Storage is a backend holding whole objects by key, such as a backup
+// target
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte) error
+	// Get returns ErrNotFound for missing keys
+	Get(ctx context.Context, key string) ([]byte, error)
+	// List returns every key under prefix
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Credentials are what a client of an API presented: a bearer token, or
+// a username and password
+type Credentials struct {
+	Token    string
+	Username string
+	Password string
+}
+
+// Identity is who an auth provider found the credentials to belong to
+type Identity struct {
+	Subject string
+	Roles   []string
+	Attrs   map[string]string
+}
+
+// Auth checks the credentials of API clients
+type Auth interface {
+	// Authenticate returns ErrDenied for credentials it refuses
+	Authenticate(ctx context.Context, creds Credentials) (*Identity, error)
+}
+
+// Scanner checks the content of files as they are stored. Scan returns a
+// description of what it found, such as the name of a virus, or "" when
+// the content is clean.
+type Scanner interface {
+	Scan(ctx context.Context, key string, data []byte) (string, error)
+}
+
+// Event is something that happened to a file, such as "file.created" or
+// "file.conflict". Data is the JSON encoded detail of the event, if any.
+type Event struct {
+	Type string
+	Key  string
+	Size int64
+	Time time.Time
+	Data []byte
+}
+
+// EventSink receives the events of the node
+type EventSink interface {
+	Handle(ctx context.Context, e Event) error
+}
+
+// Plugins are the capabilities a plugin provides; nil ones are not
+// provided
+type Plugins struct {
+	Name    string
+	Version string
+
+	Storage Storage
+	Auth    Auth
+	Scanner Scanner
+	Events  EventSink
+}
+
+// Info describes a launched plugin
+type Info struct {
+	Name         string
+	Version      string
+	Protocol     int
+	Capabilities []string
+}
+
+// Has reports whether the plugin provides capability
+func (i Info) Has(capability string) bool {
+	return slices.Contains(i.Capabilities, capability)
+}
+
+func (p Plugins) info() Info {
+	info := Info{Name: p.Name, Version: p.Version, Protocol: ProtocolVersion}
+	if p.Storage != nil {
+		info.Capabilities = append(info.Capabilities, CapStorage)
+	}
+	if p.Auth != nil {
+		info.Capabilities = append(info.Capabilities, CapAuth)
+	}
+	if p.Scanner != nil {
+		info.Capabilities = append(info.Capabilities, CapScanner)
+	}
+	if p.Events != nil {
+		info.Capabilities = append(info.Capabilities, CapEvents)
+	}
+	return info
+}
@@ -0,0 +1,238 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveEnv makes the test binary serve testPlugins instead of running the
+// tests, so the tests launch themselves as the plugin
+const serveEnv = "PEERVAULT_PLUGIN_TEST_SERVE"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(serveEnv) {
+	case "all":
+		if err := Serve(testPlugins()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "scanner":
+		if err := Serve(Plugins{Name: "scanner-only", Scanner: testPlugins().Scanner}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func testPlugins() Plugins {
+	return Plugins{
+		Name:    "test",
+		Version: "1.2.3",
+		Storage: &memStorage{objects: map[string][]byte{}},
+		Auth:    tokenAuth{},
+		Scanner: keywordScanner{},
+		Events:  stderrSink{},
+	}
+}
+
+type memStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *memStorage) Put(ctx context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	return nil
+}
+
+func (s *memStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("get %s: %w", key, ErrNotFound)
+	}
+	return data, nil
+}
+
+func (s *memStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *memStorage) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+type tokenAuth struct{}
+
+func (tokenAuth) Authenticate(ctx context.Context, creds Credentials) (*Identity, error) {
+	switch {
+	case creds.Token == "alice-token":
+		return &Identity{Subject: "alice", Roles: []string{"admin"}}, nil
+	case creds.Token == "slow":
+		<-ctx.Done()
+		return nil, ctx.Err()
+	default:
+		return nil, ErrDenied
+	}
+}
+
+type keywordScanner struct{}
+
+func (keywordScanner) Scan(ctx context.Context, key string, data []byte) (string, error) {
+	// Stray output must not break the connection
+	fmt.Println("scanning", key)
+	if strings.Contains(string(data), "EICAR") {
+		return "Eicar-Test-Signature", nil
+	}
+	return "", nil
+}
+
+type stderrSink struct{}
+
+func (stderrSink) Handle(ctx context.Context, e Event) error {
+	if e.Type == "" {
+		return errors.New("event without a type")
+	}
+	fmt.Fprintln(os.Stderr, "event", e.Type, e.Key)
+	return nil
+}
+
+// lockedBuffer collects what plugins log
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func launch(t *testing.T, mode string, stderr *lockedBuffer) *Client {
+	t.Helper()
+	c, err := Launch(Config{
+		Path:   os.Args[0],
+		Env:    []string{serveEnv + "=" + mode},
+		Stderr: stderr,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestPluginCapabilities(t *testing.T) {
+	var stderr lockedBuffer
+	c := launch(t, "all", &stderr)
+	assert.Equal(t, "test", c.Info().Name)
+	assert.Equal(t, "1.2.3", c.Info().Version)
+	assert.Equal(t, []string{CapStorage, CapAuth, CapScanner, CapEvents}, c.Info().Capabilities)
+	ctx := context.Background()
+
+	storage := c.Storage()
+	require.NotNil(t, storage)
+	require.NoError(t, storage.Put(ctx, "backups/a", []byte("one")))
+	require.NoError(t, storage.Put(ctx, "backups/b", []byte("two")))
+	data, err := storage.Get(ctx, "backups/a")
+	require.NoError(t, err)
+	assert.Equal(t, "one", string(data))
+	keys, err := storage.List(ctx, "backups/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"backups/a", "backups/b"}, keys)
+	require.NoError(t, storage.Delete(ctx, "backups/a"))
+	_, err = storage.Get(ctx, "backups/a")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Contains(t, err.Error(), "get backups/a")
+
+	auth := c.Auth()
+	require.NotNil(t, auth)
+	identity, err := auth.Authenticate(ctx, Credentials{Token: "alice-token"})
+	require.NoError(t, err)
+	assert.Equal(t, &Identity{Subject: "alice", Roles: []string{"admin"}}, identity)
+	_, err = auth.Authenticate(ctx, Credentials{Token: "mallory"})
+	assert.ErrorIs(t, err, ErrDenied)
+
+	// The deadline of the server's context reaches the plugin
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = auth.Authenticate(short, Credentials{Token: "slow"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	scanner := c.Scanner()
+	require.NotNil(t, scanner)
+	finding, err := scanner.Scan(ctx, "a.txt", []byte("X5O!P%@AP EICAR"))
+	require.NoError(t, err)
+	assert.Equal(t, "Eicar-Test-Signature", finding)
+	finding, err = scanner.Scan(ctx, "b.txt", []byte("clean"))
+	require.NoError(t, err)
+	assert.Empty(t, finding)
+
+	sink := c.EventSink()
+	require.NotNil(t, sink)
+	require.NoError(t, sink.Handle(ctx, Event{Type: "file.created", Key: "a.txt", Time: time.Now()}))
+	err = sink.Handle(ctx, Event{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "event without a type")
+
+	require.NoError(t, c.Close())
+	<-c.Exited()
+	assert.Contains(t, stderr.String(), "scanning a.txt")
+	assert.Contains(t, stderr.String(), "event file.created a.txt")
+	_, err = storage.Get(ctx, "backups/b")
+	assert.ErrorIs(t, err, ErrExited)
+}
+
+func TestPluginWithoutCapabilities(t *testing.T) {
+	c := launch(t, "scanner", &lockedBuffer{})
+	assert.Equal(t, "scanner-only", c.Info().Name)
+	assert.Nil(t, c.Storage())
+	assert.Nil(t, c.Auth())
+	assert.Nil(t, c.EventSink())
+	assert.NotNil(t, c.Scanner())
+
+	// Calling a capability the plugin lacks anyway is refused
+	err := remoteStorage{c}.Put(context.Background(), "a", nil)
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestServeOutsideServer(t *testing.T) {
+	t.Setenv(CookieKey, "")
+	assert.ErrorIs(t, Serve(Plugins{}), ErrNotLaunched)
+
+	_, err := Launch(Config{Path: "/nonexistent/plugin"})
+	assert.ErrorContains(t, err, "plugin plugin:")
+}
@@ -0,0 +1,199 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"os"
+	"strings"
+	"time"
+)
+
+// serviceName is the net/rpc service of a plugin
+const serviceName = "Plugin"
+
+// Request is the argument of every call to a plugin. It is exported only
+// because net/rpc requires it.
+type Request struct {
+	// Deadline of the server's context; zero when it has none
+	Deadline    time.Time
+	Key         string
+	Data        []byte
+	Credentials Credentials
+	Event       Event
+}
+
+// Response is the result of every call to a plugin. It is exported only
+// because net/rpc requires it.
+type Response struct {
+	Info     Info
+	Data     []byte
+	Keys     []string
+	Identity *Identity
+	Finding  string
+}
+
+// Serve runs the plugin until the server closes its stdin. It returns
+// ErrNotLaunched when the program was not started by the server.
+//
+// The plugin's stdout carries the calls, so Serve points os.Stdout at
+// stderr: output a plugin prints by mistake shows up in the server's log
+// instead of breaking the connection.
+func Serve(p Plugins) error {
+	if os.Getenv(CookieKey) != CookieValue {
+		return ErrNotLaunched
+	}
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	return ServeConn(p, &pipeConn{r: os.Stdin, w: out})
+}
+
+// ServeConn runs the plugin on conn until it is closed. Serve calls it
+// with the plugin's stdin and stdout; it is exported for hosts embedding
+// plugins in process and for tests.
+func ServeConn(p Plugins, conn io.ReadWriteCloser) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName(serviceName, &service{p: p}); err != nil {
+		return err
+	}
+	server.ServeConn(conn)
+	return nil
+}
+
+// pipeConn joins the two ends of a pair of pipes into a connection
+type pipeConn struct {
+	r io.ReadCloser
+	w io.WriteCloser
+}
+
+func (c *pipeConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c *pipeConn) Write(p []byte) (int, error) { return c.w.Write(p) }
+
+func (c *pipeConn) Close() error {
+	return errors.Join(c.w.Close(), c.r.Close())
+}
+
+// service serves the calls of the server
+type service struct {
+	p Plugins
+}
+
+func (s *service) context(req Request) (context.Context, context.CancelFunc) {
+	if req.Deadline.IsZero() {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithDeadline(context.Background(), req.Deadline)
+}
+
+func (s *service) Info(req Request, resp *Response) error {
+	resp.Info = s.p.info()
+	return nil
+}
+
+func (s *service) Put(req Request, resp *Response) error {
+	if s.p.Storage == nil {
+		return ErrUnsupported
+	}
+	ctx, cancel := s.context(req)
+	defer cancel()
+	return encodeError(s.p.Storage.Put(ctx, req.Key, req.Data))
+}
+
+func (s *service) Get(req Request, resp *Response) error {
+	if s.p.Storage == nil {
+		return ErrUnsupported
+	}
+	ctx, cancel := s.context(req)
+	defer cancel()
+	data, err := s.p.Storage.Get(ctx, req.Key)
+	resp.Data = data
+	return encodeError(err)
+}
+
+func (s *service) List(req Request, resp *Response) error {
+	if s.p.Storage == nil {
+		return ErrUnsupported
+	}
+	ctx, cancel := s.context(req)
+	defer cancel()
+	keys, err := s.p.Storage.List(ctx, req.Key)
+	resp.Keys = keys
+	return encodeError(err)
+}
+
+func (s *service) Delete(req Request, resp *Response) error {
+	if s.p.Storage == nil {
+		return ErrUnsupported
+	}
+	ctx, cancel := s.context(req)
+	defer cancel()
+	return encodeError(s.p.Storage.Delete(ctx, req.Key))
+}
+
+func (s *service) Authenticate(req Request, resp *Response) error {
+	if s.p.Auth == nil {
+		return ErrUnsupported
+	}
+	ctx, cancel := s.context(req)
+	defer cancel()
+	identity, err := s.p.Auth.Authenticate(ctx, req.Credentials)
+	if err == nil && identity == nil {
+		err = ErrDenied
+	}
+	resp.Identity = identity
+	return encodeError(err)
+}
+
+func (s *service) Scan(req Request, resp *Response) error {
+	if s.p.Scanner == nil {
+		return ErrUnsupported
+	}
+	ctx, cancel := s.context(req)
+	defer cancel()
+	finding, err := s.p.Scanner.Scan(ctx, req.Key, req.Data)
+	resp.Finding = finding
+	return encodeError(err)
+}
+
+func (s *service) Handle(req Request, resp *Response) error {
+	if s.p.Events == nil {
+		return ErrUnsupported
+	}
+	ctx, cancel := s.context(req)
+	defer cancel()
+	return encodeError(s.p.Events.Handle(ctx, req.Event))
+}
+
+// sentinels are the errors that keep their identity across the
+// connection
+var sentinels = []error{ErrNotFound, ErrDenied, ErrUnsupported, context.DeadlineExceeded, context.Canceled}
+
+// encodeError puts the sentinel an error wraps in front of its message,
+// where decodeError finds it
+func encodeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, sentinel := range sentinels {
+		if errors.Is(err, sentinel) && err != sentinel && !strings.HasPrefix(err.Error(), sentinel.Error()+": ") {
+			return fmt.Errorf("%w: %v", sentinel, err)
+		}
+	}
+	return err
+}
+
+// decodeError turns the message of an error returned by a plugin back
+// into an error wrapping its sentinel
+func decodeError(msg string) error {
+	for _, sentinel := range sentinels {
+		if msg == sentinel.Error() {
+			return sentinel
+		}
+		if rest, ok := strings.CutPrefix(msg, sentinel.Error()+": "); ok {
+			return fmt.Errorf("%w: %s", sentinel, rest)
+		}
+	}
+	return errors.New(msg)
+}
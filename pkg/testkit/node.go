@@ -0,0 +1,307 @@
+package testkit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/chaos"
+)
+
+// listenPort is the port every node listens on, on a host of its own
+const listenPort = "3000"
+
+// Node is a node of a Cluster. It keeps its ID, address and files across
+// restarts.
+type Node struct {
+	cluster *Cluster
+	index   int
+	id      string
+	host    string
+	addr    string
+	dir     string
+	opts    Options
+
+	mu        sync.Mutex
+	server    *fs.Server
+	transport *netp2p.TCPTransport
+	injector  *chaos.Injector // nil where chaos is unavailable
+	running   bool
+}
+
+func newNode(c *Cluster, index int, opts Options) (*Node, error) {
+	host := fmt.Sprintf("node-%d", index+1)
+	n := &Node{
+		cluster: c,
+		index:   index,
+		id:      crypto.GenerateID(),
+		host:    host,
+		addr:    host + ":" + listenPort,
+		dir:     filepath.Join(c.dir, host),
+		opts:    opts,
+	}
+	if !chaos.Available() {
+		if opts.Faults != (Faults{}) {
+			return nil, chaos.ErrUnavailable
+		}
+		return n, nil
+	}
+	var seed uint64
+	if opts.Seed != 0 {
+		seed = opts.Seed + uint64(index)
+	}
+	injector, err := chaos.New(chaos.Options{Self: []string{n.id, n.addr}, Faults: opts.Faults, Seed: seed})
+	if err != nil {
+		return nil, err
+	}
+	n.injector = injector
+	return n, nil
+}
+
+// start runs a new server for the node and dials the running nodes it can
+// reach that were started before it, or all of them on a restart
+func (n *Node) start() error {
+	var bootstrap []string
+	for _, other := range n.cluster.nodes {
+		if other == n {
+			if !n.restarting() {
+				break
+			}
+			continue
+		}
+		if other.Running() && n.cluster.reachable(n, other) {
+			bootstrap = append(bootstrap, other.addr)
+		}
+	}
+
+	transport := netp2p.NewTCPTransport(netp2p.TCPTransportOpts{
+		ListenAddr:    n.addr,
+		HandshakeFunc: netp2p.NewHandshakeFunc(n.id, netp2p.HandshakeOptions{Secret: secret}),
+		Decoder:       netp2p.LengthPrefixedDecoder{},
+		Network:       n.cluster.network.Host(n.host),
+	})
+	server := fs.New(fs.Options{
+		ID:                n.id,
+		EncKey:            crypto.NewEncryptionKey(),
+		StorageRoot:       n.dir,
+		Transport:         transport,
+		BootstrapNodes:    bootstrap,
+		ReplicationFactor: n.opts.ReplicationFactor,
+	})
+	transport.OnPeer = server.OnPeer
+	transport.Hook = &hook{injector: n.injector, server: server}
+	if err := server.Start(); err != nil {
+		server.Stop()
+		return fmt.Errorf("testkit: starting %s: %w", n.host, err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.server, n.transport, n.running = server, transport, true
+	return nil
+}
+
+// restarting reports whether the node ran before
+func (n *Node) restarting() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.server != nil
+}
+
+// dial connects the node to other
+func (n *Node) dial(other *Node) {
+	n.mu.Lock()
+	transport := n.transport
+	n.mu.Unlock()
+	if transport != nil {
+		_ = transport.Dial(other.addr)
+	}
+}
+
+// ID returns the node ID the node authenticates as
+func (n *Node) ID() string { return n.id }
+
+// Addr returns the address the node listens on in the cluster's network
+func (n *Node) Addr() string { return n.addr }
+
+// Dir returns the storage root of the node
+func (n *Node) Dir() string { return n.dir }
+
+// Server returns the file server of the node, for tests within PeerVault
+// that need more than the methods of Node
+func (n *Node) Server() *fs.Server {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.server
+}
+
+// Running reports whether the node is started
+func (n *Node) Running() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.running
+}
+
+// runningServer returns the server of a running node
+func (n *Node) runningServer() (*fs.Server, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.running {
+		return nil, errStopped
+	}
+	return n.server, nil
+}
+
+// Stop stops the node and closes its connections, as when it crashes; its
+// files stay for a Restart
+func (n *Node) Stop() {
+	n.mu.Lock()
+	if !n.running {
+		n.mu.Unlock()
+		return
+	}
+	n.running = false
+	server, transport := n.server, n.transport
+	n.mu.Unlock()
+
+	server.Stop()
+	// The listener is released at once so a restart can take the address
+	_ = transport.Close()
+	n.cluster.network.Disconnect(n.host)
+}
+
+// Restart starts a stopped node again and reconnects it to the running
+// nodes it can reach
+func (n *Node) Restart() error {
+	if n.Running() {
+		return nil
+	}
+	return n.start()
+}
+
+// Store stores a file through the node
+func (n *Node) Store(ctx context.Context, key string, r io.Reader) error {
+	server, err := n.runningServer()
+	if err != nil {
+		return err
+	}
+	return server.Store(ctx, key, r)
+}
+
+// StoreBytes stores data under key through the node
+func (n *Node) StoreBytes(ctx context.Context, key string, data []byte) error {
+	return n.Store(ctx, key, bytes.NewReader(data))
+}
+
+// Get reads a file through the node, which fetches it from its peers when
+// it holds no copy
+func (n *Node) Get(ctx context.Context, key string) ([]byte, error) {
+	server, err := n.runningServer()
+	if err != nil {
+		return nil, err
+	}
+	r, err := server.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// Delete deletes a file through the node
+func (n *Node) Delete(ctx context.Context, key string) error {
+	server, err := n.runningServer()
+	if err != nil {
+		return err
+	}
+	return server.Delete(ctx, key)
+}
+
+// Has reports whether the node holds a copy of the file, stored through it
+// or replicated from a peer
+func (n *Node) Has(key string) bool {
+	server := n.Server()
+	if server == nil {
+		return false
+	}
+	store := server.Storage()
+	return store.Has(key) || store.Has(crypto.HashKey(key))
+}
+
+// Peers returns the IDs of the nodes this one is connected to
+func (n *Node) Peers() []string {
+	server, err := n.runningServer()
+	if err != nil {
+		return nil
+	}
+	var ids []string
+	for _, p := range server.Peers() {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+// ConnectedTo reports whether the node is connected to other
+func (n *Node) ConnectedTo(other *Node) bool {
+	for _, id := range n.Peers() {
+		if id == other.id {
+			return true
+		}
+	}
+	return false
+}
+
+// SetFaults replaces the faults injected into the messages the node
+// receives. It fails with chaos.ErrUnavailable outside of test binaries
+// and chaos builds.
+func (n *Node) SetFaults(f Faults) error {
+	if n.injector == nil {
+		if f == (Faults{}) {
+			return nil
+		}
+		return chaos.ErrUnavailable
+	}
+	return n.injector.SetFaults(f)
+}
+
+// Stats returns the faults injected into the node's messages so far
+func (n *Node) Stats() Stats {
+	if n.injector == nil {
+		return Stats{}
+	}
+	return n.injector.Stats()
+}
+
+// hook passes the node's connections and messages through its fault
+// injector, and drops the connections that end from the server's peers
+// so they are not used after a partition or a crash
+type hook struct {
+	injector *chaos.Injector
+	server   *fs.Server
+}
+
+func (h *hook) OnConnect(p *netp2p.TCPPeer) error {
+	if h.injector == nil {
+		return nil
+	}
+	return h.injector.OnConnect(p)
+}
+
+func (h *hook) OnMessage(p *netp2p.TCPPeer, rpc netp2p.RPC) []netp2p.RPC {
+	if h.injector == nil {
+		return []netp2p.RPC{rpc}
+	}
+	return h.injector.OnMessage(p, rpc)
+}
+
+func (h *hook) OnDisconnect(p *netp2p.TCPPeer) {
+	if h.injector != nil {
+		h.injector.OnDisconnect(p)
+	}
+	h.server.Disconnect(p.RemoteAddr().String())
+}
@@ -0,0 +1,288 @@
+package testkit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario is a script for a Simulation, as run by peervault-sim:
+//
+//	name: member-isolated
+//	seed: 42
+//	nodes: 5
+//	network: {min_latency: 1ms, max_latency: 10ms, drop: 0.01}
+//	steps:
+//	  - expect: {leader: true, within: 5s}
+//	  - propose: [a, b]
+//	  - partition: [[0], [1, 2, 3, 4]]
+//	    run: 3s
+//	  - propose: [c]
+//	    run: 1s
+//	  - heal: true
+//	  - expect: {converged: true, applied: 3, within: 10s}
+type Scenario struct {
+	Name string `yaml:"name" json:"name"`
+	// Seed decides the run; zero picks a random seed
+	Seed              uint64        `yaml:"seed" json:"seed"`
+	Nodes             int           `yaml:"nodes" json:"nodes"`
+	Network           Network       `yaml:"network" json:"network"`
+	ElectionTimeout   time.Duration `yaml:"election_timeout" json:"election_timeout"`
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval" json:"heartbeat_interval"`
+	SnapshotThreshold uint64        `yaml:"snapshot_threshold" json:"snapshot_threshold"`
+	Steps             []Step        `yaml:"steps" json:"steps"`
+}
+
+// Step is one step of a scenario. Its fields apply in the order they are
+// declared.
+type Step struct {
+	// Network replaces the latencies and drop rate; nil keeps them
+	Network *Network `yaml:"network" json:"network,omitempty"`
+	// Heal lifts the partitions of earlier steps
+	Heal bool `yaml:"heal" json:"heal,omitempty"`
+	// Partition splits the group into groups of member indexes
+	Partition [][]int `yaml:"partition" json:"partition,omitempty"`
+	// Crash and Restart stop and start members, by index
+	Crash   []int `yaml:"crash" json:"crash,omitempty"`
+	Restart []int `yaml:"restart" json:"restart,omitempty"`
+	// Propose appends commands on the leader, waiting up to ten election
+	// timeouts for one to be elected. Commands are not committed yet when
+	// the step ends; a partition or crash right after may lose them.
+	Propose []string `yaml:"propose" json:"propose,omitempty"`
+	// Run advances the simulation
+	Run time.Duration `yaml:"run" json:"run,omitempty"`
+	// Expect runs until the group is in the expected state
+	Expect *Expectation `yaml:"expect" json:"expect,omitempty"`
+}
+
+// Expectation is the state a step waits for
+type Expectation struct {
+	// Leader expects a leader to be elected
+	Leader bool `yaml:"leader" json:"leader,omitempty"`
+	// Converged expects every running member reachable from the leader
+	// to have applied its whole log
+	Converged bool `yaml:"converged" json:"converged,omitempty"`
+	// Applied is the number of commands each running member has applied
+	// at least
+	Applied int `yaml:"applied" json:"applied,omitempty"`
+	// Within is how much virtual time the state may take; zero checks the
+	// state as it is
+	Within time.Duration `yaml:"within" json:"within,omitempty"`
+}
+
+// SimReport is the outcome of a scenario
+type SimReport struct {
+	Scenario    string        `json:"scenario"`
+	Seed        uint64        `json:"seed"`
+	Passed      bool          `json:"passed"`
+	Error       string        `json:"error,omitempty"`
+	Elapsed     time.Duration `json:"elapsed"`
+	Fingerprint string        `json:"fingerprint"`
+	Leader      string        `json:"leader,omitempty"`
+	// Applied counts the commands each member applied
+	Applied map[string]int `json:"applied"`
+	Trace   []string       `json:"trace,omitempty"`
+}
+
+// Validate checks the steps of the scenario
+func (s *Scenario) Validate() error {
+	if len(s.Steps) == 0 {
+		return fmt.Errorf("testkit: scenario %q has no steps", s.Name)
+	}
+	if s.Nodes < 0 {
+		return fmt.Errorf("testkit: scenario %q: invalid number of nodes %d", s.Name, s.Nodes)
+	}
+	if err := s.Network.Validate(); err != nil {
+		return fmt.Errorf("scenario %q: %w", s.Name, err)
+	}
+	nodes := s.Nodes
+	if nodes == 0 {
+		nodes = DefaultNodes
+	}
+	member := func(n, i int) error {
+		if i < 0 || i >= nodes {
+			return fmt.Errorf("testkit: step %d of %q: no member %d", n+1, s.Name, i)
+		}
+		return nil
+	}
+	for n, step := range s.Steps {
+		if step.Run < 0 {
+			return fmt.Errorf("testkit: step %d of %q: negative run", n+1, s.Name)
+		}
+		if step.Network != nil {
+			if err := step.Network.Validate(); err != nil {
+				return fmt.Errorf("step %d of %q: %w", n+1, s.Name, err)
+			}
+		}
+		for _, group := range step.Partition {
+			for _, i := range group {
+				if err := member(n, i); err != nil {
+					return err
+				}
+			}
+		}
+		for _, i := range append(append([]int(nil), step.Crash...), step.Restart...) {
+			if err := member(n, i); err != nil {
+				return err
+			}
+		}
+		if step.Expect != nil && step.Expect.Within < 0 {
+			return fmt.Errorf("testkit: step %d of %q: negative wait", n+1, s.Name)
+		}
+	}
+	return nil
+}
+
+// LoadScenario reads a scenario from a YAML file
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseScenario(data)
+}
+
+// ParseScenario parses a scenario in YAML
+func ParseScenario(data []byte) (*Scenario, error) {
+	var s Scenario
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("testkit: failed to parse scenario: %w", err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// RunScenario plays a scenario and reports how it went. The report fails
+// when an expectation is not met or an invariant broken; the error is
+// only for scenarios that cannot run at all.
+func RunScenario(sc *Scenario) (*SimReport, error) {
+	if err := sc.Validate(); err != nil {
+		return nil, err
+	}
+	s, err := NewSimulation(SimOptions{
+		Seed:              sc.Seed,
+		Nodes:             sc.Nodes,
+		Network:           sc.Network,
+		ElectionTimeout:   sc.ElectionTimeout,
+		HeartbeatInterval: sc.HeartbeatInterval,
+		SnapshotThreshold: sc.SnapshotThreshold,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	report := &SimReport{Scenario: sc.Name, Seed: s.Seed(), Applied: make(map[string]int)}
+	runErr := s.play(sc)
+	if runErr == nil {
+		runErr = s.Check()
+	}
+	report.Passed = runErr == nil
+	if runErr != nil {
+		report.Error = runErr.Error()
+	}
+	report.Elapsed = s.Elapsed()
+	report.Fingerprint = s.Fingerprint()
+	if i, ok := s.Leader(); ok {
+		report.Leader = s.members[i].id
+	}
+	for i, m := range s.members {
+		report.Applied[m.id] = len(s.Applied(i))
+	}
+	report.Trace = s.Trace()
+	return report, nil
+}
+
+// play runs the steps of a scenario
+func (s *Simulation) play(sc *Scenario) error {
+	for n, step := range sc.Steps {
+		if err := s.step(step); err != nil {
+			return fmt.Errorf("step %d: %w", n+1, err)
+		}
+		if err := s.Check(); err != nil {
+			return fmt.Errorf("step %d: %w", n+1, err)
+		}
+	}
+	return nil
+}
+
+func (s *Simulation) step(step Step) error {
+	if step.Network != nil {
+		if err := s.SetNetwork(*step.Network); err != nil {
+			return err
+		}
+	}
+	if step.Heal {
+		s.Heal()
+	}
+	if len(step.Partition) > 0 {
+		s.Partition(step.Partition...)
+	}
+	for _, i := range step.Crash {
+		s.Crash(i)
+	}
+	for _, i := range step.Restart {
+		if err := s.Restart(i); err != nil {
+			return err
+		}
+	}
+	for _, command := range step.Propose {
+		if _, ok := s.Leader(); !ok {
+			s.RunUntil(func() bool { _, ok := s.Leader(); return ok }, 10*s.opts.ElectionTimeout)
+		}
+		if _, err := s.Propose(command); err != nil {
+			return fmt.Errorf("proposing %q: %w", command, err)
+		}
+	}
+	s.Run(step.Run)
+	if step.Expect != nil {
+		return s.expect(*step.Expect)
+	}
+	return nil
+}
+
+// expect runs until the expectation is met, or fails after its wait
+func (s *Simulation) expect(e Expectation) error {
+	met := func() bool {
+		if _, ok := s.Leader(); e.Leader && !ok {
+			return false
+		}
+		if e.Converged && !s.Converged() {
+			return false
+		}
+		for i, m := range s.members {
+			if m.node != nil && len(s.Applied(i)) < e.Applied {
+				return false
+			}
+		}
+		return true
+	}
+	if s.RunUntil(func() bool { return s.violation != nil || met() }, e.Within) && s.violation == nil {
+		return nil
+	}
+	if s.violation != nil {
+		return s.violation
+	}
+	return errors.New(s.describe(e))
+}
+
+// describe explains which part of an expectation is not met
+func (s *Simulation) describe(e Expectation) string {
+	if _, ok := s.Leader(); e.Leader && !ok {
+		return fmt.Sprintf("no leader elected within %s", e.Within)
+	}
+	if e.Converged && !s.Converged() {
+		return fmt.Sprintf("group did not converge within %s", e.Within)
+	}
+	for i, m := range s.members {
+		if m.node != nil && len(s.Applied(i)) < e.Applied {
+			return fmt.Sprintf("%s applied %d of %d commands within %s", m.id, len(s.Applied(i)), e.Applied, e.Within)
+		}
+	}
+	return "expectation not met"
+}
@@ -0,0 +1,430 @@
+package testkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/consensus"
+	"github.com/Skpow1234/Peervault/internal/sim"
+)
+
+// Network is how a Simulation delays and drops messages; see sim.Network
+type Network = sim.Network
+
+// errUnreachable fails the requests a simulated network loses
+var errUnreachable = errors.New("testkit: simulated request lost")
+
+// SimOptions configure a Simulation
+type SimOptions struct {
+	// Seed decides the timers, latencies and losses of the run; zero picks
+	// a random seed, which Seed returns so a failing run can be replayed
+	Seed uint64
+	// Nodes is the number of members of the Raft group; zero uses
+	// DefaultNodes
+	Nodes int
+	// Network delays and drops the requests between members
+	Network Network
+	// ElectionTimeout and HeartbeatInterval are those of the members; zero
+	// uses 150ms and a tenth of the election timeout
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration
+	// SnapshotThreshold is the number of applied entries after which the
+	// members compact their log; zero uses the default of consensus
+	SnapshotThreshold uint64
+	// Dir persists the state of the members, which survives their crashes;
+	// empty uses a temporary directory removed by Close
+	Dir string
+}
+
+// Simulation runs a Raft group deterministically: the members share a
+// virtual clock, and their timers, background work and requests run one
+// at a time in an order decided by the seed. Invariants of the protocol
+// are checked after every step; Check returns the first one broken.
+type Simulation struct {
+	world   *sim.World
+	opts    SimOptions
+	dir     string
+	tempDir bool
+	peers   []consensus.Peer
+	members []*member
+	byURL   map[string]*member
+
+	leaders   map[uint64]string // leader of each term seen
+	committed map[uint64]string // command applied at each index
+	violation error
+}
+
+// member is a node of the simulated group
+type member struct {
+	sim     *Simulation
+	id      string
+	process *sim.Process
+	node    *consensus.Node // nil while crashed
+	applied []string
+}
+
+// NewSimulation creates a group and starts its members. Nothing happens
+// until the simulation runs.
+func NewSimulation(opts SimOptions) (*Simulation, error) {
+	if opts.Seed == 0 {
+		opts.Seed = rand.Uint64()
+	}
+	if opts.Nodes < 0 {
+		return nil, fmt.Errorf("testkit: invalid number of nodes %d", opts.Nodes)
+	}
+	if opts.Nodes == 0 {
+		opts.Nodes = DefaultNodes
+	}
+	if opts.ElectionTimeout <= 0 {
+		opts.ElectionTimeout = 150 * time.Millisecond
+	}
+	s := &Simulation{
+		world:     sim.New(opts.Seed),
+		opts:      opts,
+		dir:       opts.Dir,
+		byURL:     make(map[string]*member),
+		leaders:   make(map[uint64]string),
+		committed: make(map[uint64]string),
+	}
+	if err := s.world.SetNetwork(opts.Network); err != nil {
+		return nil, err
+	}
+	if s.dir == "" {
+		dir, err := os.MkdirTemp("", "peervault-sim-")
+		if err != nil {
+			return nil, fmt.Errorf("testkit: %w", err)
+		}
+		s.dir, s.tempDir = dir, true
+	}
+	for i := range opts.Nodes {
+		id := fmt.Sprintf("n%d", i+1)
+		m := &member{sim: s, id: id, process: s.world.Process(id)}
+		s.peers = append(s.peers, consensus.Peer{ID: id, URL: "sim://" + id})
+		s.members = append(s.members, m)
+		s.byURL["sim://"+id] = m
+	}
+	for _, m := range s.members {
+		if err := m.start(); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	s.world.Observe(s.check)
+	return s, nil
+}
+
+// start runs a new node for the member from its persisted state
+func (m *member) start() error {
+	m.applied = nil
+	node, err := consensus.NewNode(consensus.Options{
+		ID:                m.id,
+		Peers:             m.sim.peers,
+		Dir:               filepath.Join(m.sim.dir, m.id),
+		ElectionTimeout:   m.sim.opts.ElectionTimeout,
+		HeartbeatInterval: m.sim.opts.HeartbeatInterval,
+		SnapshotThreshold: m.sim.opts.SnapshotThreshold,
+		Runtime:           m.process,
+		Transport:         simTransport{from: m},
+	}, memberState{m})
+	if err != nil {
+		return fmt.Errorf("testkit: starting %s: %w", m.id, err)
+	}
+	m.node = node
+	node.Start()
+	return nil
+}
+
+// Close stops the members and removes the temporary directory of the
+// simulation
+func (s *Simulation) Close() {
+	for _, m := range s.members {
+		if m.node != nil {
+			m.node.Stop()
+		}
+	}
+	if s.tempDir {
+		_ = os.RemoveAll(s.dir)
+	}
+}
+
+// Seed returns the seed of the run
+func (s *Simulation) Seed() uint64 { return s.opts.Seed }
+
+// Elapsed returns the virtual time the simulation ran for
+func (s *Simulation) Elapsed() time.Duration { return s.world.Elapsed() }
+
+// Trace returns the leader changes, commands, faults and applied entries
+// of the run, stamped with the virtual time
+func (s *Simulation) Trace() []string { return s.world.Trace() }
+
+// Fingerprint summarizes the trace: runs with the same seed and script
+// have the same fingerprint
+func (s *Simulation) Fingerprint() string { return s.world.Fingerprint() }
+
+// Run advances the simulation by d of virtual time
+func (s *Simulation) Run(d time.Duration) { s.world.Run(d) }
+
+// RunUntil runs until cond holds or limit of virtual time passed,
+// reporting whether cond held
+func (s *Simulation) RunUntil(cond func() bool, limit time.Duration) bool {
+	return s.world.RunUntil(cond, limit)
+}
+
+// SetNetwork changes how requests are delayed and dropped
+func (s *Simulation) SetNetwork(n Network) error {
+	if err := s.world.SetNetwork(n); err != nil {
+		return err
+	}
+	s.world.Tracef("network latency %s-%s drop %v", n.MinLatency, n.MaxLatency, n.Drop)
+	return nil
+}
+
+// Partition splits the group into groups of members, by index, that
+// cannot reach each other
+func (s *Simulation) Partition(groups ...[]int) {
+	names := make([][]string, len(groups))
+	for i, group := range groups {
+		for _, n := range group {
+			names[i] = append(names[i], s.members[n].id)
+		}
+	}
+	s.world.Partition(names...)
+}
+
+// Heal lifts the partitions
+func (s *Simulation) Heal() { s.world.Heal() }
+
+// Crash stops the i-th member; it loses everything it did not persist
+func (s *Simulation) Crash(i int) {
+	m := s.members[i]
+	if m.node == nil {
+		return
+	}
+	m.process.Crash()
+	m.node.Stop()
+	m.node = nil
+}
+
+// Restart starts a crashed member again from its persisted state
+func (s *Simulation) Restart(i int) error {
+	m := s.members[i]
+	if m.node != nil {
+		return nil
+	}
+	m.process.Restart()
+	return m.start()
+}
+
+// Propose appends a command to the log of the current leader, failing
+// with consensus.ErrNoLeader without one. Whether it commits shows in
+// Applied.
+func (s *Simulation) Propose(command string) (uint64, error) {
+	i, ok := s.Leader()
+	if !ok {
+		return 0, consensus.ErrNoLeader
+	}
+	data, err := json.Marshal(command)
+	if err != nil {
+		return 0, err
+	}
+	index, err := s.members[i].node.Append(data)
+	if err != nil {
+		return 0, err
+	}
+	s.world.Tracef("%s appended %q at %d", s.members[i].id, command, index)
+	return index, nil
+}
+
+// Leader returns the index of the running member leading the highest term
+func (s *Simulation) Leader() (int, bool) {
+	leader, term := -1, uint64(0)
+	for i, m := range s.members {
+		if m.node == nil {
+			continue
+		}
+		if st := m.node.Status(); st.Role == consensus.RoleLeader && (leader < 0 || st.Term > term) {
+			leader, term = i, st.Term
+		}
+	}
+	return leader, leader >= 0
+}
+
+// Status returns the Raft status of the i-th member; crashed members
+// report no role
+func (s *Simulation) Status(i int) consensus.Status {
+	if m := s.members[i]; m.node != nil {
+		return m.node.Status()
+	}
+	return consensus.Status{ID: s.members[i].id}
+}
+
+// Applied returns the commands the i-th member applied since it last
+// started, in log order
+func (s *Simulation) Applied(i int) []string { return slices.Clone(s.members[i].applied) }
+
+// Converged reports whether a leader is elected and every running member
+// reachable from it applied its whole log
+func (s *Simulation) Converged() bool {
+	i, ok := s.Leader()
+	if !ok {
+		return false
+	}
+	leader := s.members[i].node.Status()
+	for _, m := range s.members {
+		if m.node == nil || !s.world.Reachable(leader.ID, m.id) {
+			continue
+		}
+		if st := m.node.Status(); st.AppliedIndex != leader.LastIndex {
+			return false
+		}
+	}
+	return true
+}
+
+// WaitConverged runs until the group converges, failing after limit of
+// virtual time
+func (s *Simulation) WaitConverged(limit time.Duration) error {
+	if !s.RunUntil(func() bool { return s.violation != nil || s.Converged() }, limit) {
+		return fmt.Errorf("testkit: group did not converge within %s (seed %d)", limit, s.Seed())
+	}
+	return s.Check()
+}
+
+// Check returns the first invariant of the protocol the run broke: two
+// leaders in one term, or two members applying different commands at the
+// same log index
+func (s *Simulation) Check() error { return s.violation }
+
+// check records the leaders seen after a step
+func (s *Simulation) check() {
+	for _, m := range s.members {
+		if m.node == nil {
+			continue
+		}
+		st := m.node.Status()
+		if st.Role != consensus.RoleLeader {
+			continue
+		}
+		switch leader, seen := s.leaders[st.Term]; {
+		case !seen:
+			s.leaders[st.Term] = m.id
+			s.world.Tracef("%s leads term %d", m.id, st.Term)
+		case leader != m.id:
+			s.fail("%s and %s both lead term %d", leader, m.id, st.Term)
+		}
+	}
+}
+
+func (s *Simulation) fail(format string, args ...any) {
+	if s.violation != nil {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	s.world.Tracef("VIOLATION %s", msg)
+	s.violation = fmt.Errorf("testkit: %s (%s)", msg, s.world)
+}
+
+// memberState is the state machine of a member: the commands it applied
+type memberState struct{ m *member }
+
+func (st memberState) Apply(index uint64, command []byte) error {
+	var c string
+	if err := json.Unmarshal(command, &c); err != nil {
+		return err
+	}
+	m, s := st.m, st.m.sim
+	m.applied = append(m.applied, c)
+	if prev, ok := s.committed[index]; !ok {
+		s.committed[index] = c
+	} else if prev != c {
+		s.fail("%s applied %q at %d where another member applied %q", m.id, c, index, prev)
+	}
+	s.world.Tracef("%s applied %q at %d", m.id, c, index)
+	return nil
+}
+
+func (st memberState) Snapshot() ([]byte, error) { return json.Marshal(st.m.applied) }
+
+func (st memberState) Restore(data []byte) error {
+	var applied []string
+	if err := json.Unmarshal(data, &applied); err != nil {
+		return err
+	}
+	st.m.applied = applied
+	return nil
+}
+
+// simTransport carries the requests of a member through the world, which
+// loses them across partitions, to crashed members and at the network's
+// drop rate. A request is answered at once; its latency is that of the
+// background work sending it.
+type simTransport struct{ from *member }
+
+// to returns the member a request reaches
+func (t simTransport) to(url string) (*member, error) {
+	m, ok := t.from.sim.byURL[url]
+	if !ok {
+		return nil, fmt.Errorf("testkit: unknown member %s", url)
+	}
+	if _, ok := t.from.sim.world.Deliver(t.from.id, m.id); !ok || m.node == nil {
+		return nil, errUnreachable
+	}
+	return m, nil
+}
+
+// back reports whether the answer of m reaches the sender
+func (t simTransport) back(m *member) error {
+	if _, ok := t.from.sim.world.Deliver(m.id, t.from.id); !ok {
+		return errUnreachable
+	}
+	return nil
+}
+
+func (t simTransport) RequestVote(url string, req consensus.VoteRequest) (consensus.VoteResponse, error) {
+	m, err := t.to(url)
+	if err != nil {
+		return consensus.VoteResponse{}, err
+	}
+	resp := m.node.HandleVote(req)
+	return resp, t.back(m)
+}
+
+func (t simTransport) AppendEntries(url string, req consensus.AppendRequest) (consensus.AppendResponse, error) {
+	m, err := t.to(url)
+	if err != nil {
+		return consensus.AppendResponse{}, err
+	}
+	resp := m.node.HandleAppend(req)
+	return resp, t.back(m)
+}
+
+func (t simTransport) InstallSnapshot(url string, req consensus.SnapshotRequest) (consensus.SnapshotResponse, error) {
+	m, err := t.to(url)
+	if err != nil {
+		return consensus.SnapshotResponse{}, err
+	}
+	resp, err := m.node.HandleSnapshot(req)
+	if err != nil {
+		return resp, err
+	}
+	return resp, t.back(m)
+}
+
+func (t simTransport) Propose(_ context.Context, url string, command []byte) (uint64, error) {
+	m, err := t.to(url)
+	if err != nil {
+		return 0, err
+	}
+	index, err := m.node.Append(command)
+	if err != nil {
+		return 0, err
+	}
+	return index, t.back(m)
+}
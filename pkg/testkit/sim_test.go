@@ -0,0 +1,111 @@
+package testkit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSimulation(t *testing.T, opts SimOptions) *Simulation {
+	t.Helper()
+	opts.Dir = t.TempDir()
+	s, err := NewSimulation(opts)
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestSimulationElectsAndReplicates(t *testing.T) {
+	s := newTestSimulation(t, SimOptions{Seed: 1, Nodes: 3, Network: Network{MinLatency: time.Millisecond, MaxLatency: 10 * time.Millisecond}})
+	require.True(t, s.RunUntil(func() bool { _, ok := s.Leader(); return ok }, 5*time.Second))
+
+	for _, c := range []string{"a", "b", "c"} {
+		_, err := s.Propose(c)
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.WaitConverged(5*time.Second))
+	for i := range 3 {
+		assert.Equal(t, []string{"a", "b", "c"}, s.Applied(i))
+	}
+	assert.Less(t, s.Elapsed(), 10*time.Second, "virtual time, not wall time")
+}
+
+func TestSimulationIsDeterministic(t *testing.T) {
+	run := func(seed uint64) string {
+		s := newTestSimulation(t, SimOptions{Seed: seed, Nodes: 5, Network: Network{MaxLatency: 20 * time.Millisecond, Drop: 0.05}})
+		s.Run(2 * time.Second)
+		for _, c := range []string{"x", "y"} {
+			_, _ = s.Propose(c)
+		}
+		s.Partition([]int{0, 1}, []int{2, 3, 4})
+		s.Run(2 * time.Second)
+		s.Heal()
+		s.Run(2 * time.Second)
+		require.NoError(t, s.Check())
+		return s.Fingerprint()
+	}
+	assert.Equal(t, run(7), run(7))
+	assert.NotEqual(t, run(7), run(8))
+}
+
+func TestSimulationSurvivesCrashes(t *testing.T) {
+	s := newTestSimulation(t, SimOptions{Seed: 3, Nodes: 3, SnapshotThreshold: 4})
+	require.True(t, s.RunUntil(func() bool { _, ok := s.Leader(); return ok }, 5*time.Second))
+	leader, _ := s.Leader()
+	for _, c := range []string{"a", "b", "c", "d", "e"} {
+		_, err := s.Propose(c)
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.WaitConverged(5*time.Second))
+
+	s.Crash(leader)
+	assert.Empty(t, s.Status(leader).Role)
+	require.True(t, s.RunUntil(func() bool { i, ok := s.Leader(); return ok && i != leader }, 5*time.Second))
+	_, err := s.Propose("f")
+	require.NoError(t, err)
+	require.NoError(t, s.Restart(leader))
+	require.NoError(t, s.WaitConverged(5*time.Second))
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f"}, s.Applied(leader), "the restarted member catches up from its snapshot and log")
+}
+
+func TestScenario(t *testing.T) {
+	sc, err := ParseScenario([]byte(`
+name: member-isolated
+seed: 42
+nodes: 5
+network: {min_latency: 1ms, max_latency: 10ms}
+steps:
+  - expect: {leader: true, within: 5s}
+  - propose: [a, b]
+  - partition: [[0], [1, 2, 3, 4]]
+    run: 2s
+  - propose: [c]
+    run: 1s
+  - heal: true
+  - expect: {converged: true, applied: 3, within: 10s}
+`))
+	require.NoError(t, err)
+	report, err := RunScenario(sc)
+	require.NoError(t, err)
+	assert.True(t, report.Passed, report.Error)
+	assert.Equal(t, uint64(42), report.Seed)
+	assert.NotEmpty(t, report.Leader)
+	assert.NotEmpty(t, report.Trace)
+
+	again, err := RunScenario(sc)
+	require.NoError(t, err)
+	assert.Equal(t, report.Fingerprint, again.Fingerprint)
+
+	sc.Steps = append(sc.Steps, Step{Crash: []int{0, 1, 2, 3, 4}, Expect: &Expectation{Leader: true, Within: 5 * time.Second}})
+	report, err = RunScenario(sc)
+	require.NoError(t, err)
+	assert.False(t, report.Passed, "a group with every member down has no leader")
+	assert.Contains(t, report.Error, "no leader")
+
+	_, err = ParseScenario([]byte("name: empty\n"))
+	assert.Error(t, err)
+	_, err = ParseScenario([]byte("name: bad\nsteps:\n  - crash: [7]\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,294 @@
+// Package testkit runs clusters of PeerVault nodes inside a test process,
+// so code built on PeerVault can be tested against a real cluster without
+// ports, containers or fixed sleeps:
+//
+//	func TestReplication(t *testing.T) {
+//		c := testkit.Start(t, testkit.Options{Nodes: 3})
+//		ctx := context.Background()
+//		require.NoError(t, c.Node(0).Store(ctx, "photo.jpg", bytes.NewReader(data)))
+//		require.NoError(t, c.WaitReplicated(ctx, "photo.jpg", 3))
+//		got, err := c.Node(2).Get(ctx, "photo.jpg")
+//		...
+//	}
+//
+// The nodes talk over a p2p.MemoryNetwork with the handshake, codecs and
+// limits of TCP links, and keep their files in a temporary directory each.
+// Faults are injected by partitioning the network, stopping and
+// restarting nodes, and, in test binaries or chaos builds, by dropping,
+// delaying, duplicating or corrupting messages. The Wait methods poll
+// until the cluster converges or their context is done.
+package testkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/chaos"
+)
+
+// DefaultNodes is the size of clusters started without a size
+const DefaultNodes = 3
+
+// pollInterval is how often the Wait methods check the cluster
+const pollInterval = 20 * time.Millisecond
+
+// secret signs the handshakes between the nodes of a cluster
+const secret = "peervault-testkit"
+
+// Faults are the probabilities of tampering with each message a node
+// receives; see chaos.Faults
+type Faults = chaos.Faults
+
+// Stats count the faults injected into the messages of a node
+type Stats = chaos.Stats
+
+// Options configure a Cluster
+type Options struct {
+	// Nodes is the number of nodes; zero starts DefaultNodes
+	Nodes int
+	// ReplicationFactor is the number of copies of each file; zero uses
+	// the default of the nodes
+	ReplicationFactor int
+	// Dir holds the storage of the nodes, a directory each; empty uses a
+	// temporary directory removed by Close
+	Dir string
+	// Seed makes the message faults reproducible; zero picks a random seed
+	Seed uint64
+	// Faults apply to every node from the start
+	Faults Faults
+}
+
+// Cluster is a set of nodes connected over an in-memory network
+type Cluster struct {
+	network *netp2p.MemoryNetwork
+	dir     string
+	tempDir bool
+	nodes   []*Node
+
+	mu         sync.Mutex
+	partitions [][]int
+}
+
+// New starts a cluster and connects every node to the ones started before
+// it. The nodes may still be handshaking when New returns; WaitConnected
+// waits for them.
+func New(opts Options) (*Cluster, error) {
+	if opts.Nodes < 0 {
+		return nil, fmt.Errorf("testkit: invalid number of nodes %d", opts.Nodes)
+	}
+	if opts.Nodes == 0 {
+		opts.Nodes = DefaultNodes
+	}
+	if err := opts.Faults.Validate(); err != nil {
+		return nil, err
+	}
+	c := &Cluster{network: netp2p.NewMemoryNetwork(), dir: opts.Dir}
+	if c.dir == "" {
+		dir, err := os.MkdirTemp("", "peervault-testkit-")
+		if err != nil {
+			return nil, fmt.Errorf("testkit: %w", err)
+		}
+		c.dir, c.tempDir = dir, true
+	}
+	for i := range opts.Nodes {
+		n, err := newNode(c, i, opts)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.nodes = append(c.nodes, n)
+	}
+	for _, n := range c.nodes {
+		if err := n.start(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Start starts a cluster for the test and waits until its nodes are
+// connected, failing the test otherwise. The cluster is closed when the
+// test ends.
+func Start(t testing.TB, opts Options) *Cluster {
+	t.Helper()
+	if opts.Dir == "" {
+		opts.Dir = t.TempDir()
+	}
+	c, err := New(opts)
+	if err != nil {
+		t.Fatalf("testkit: starting cluster: %v", err)
+	}
+	t.Cleanup(c.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.WaitConnected(ctx); err != nil {
+		t.Fatalf("testkit: %v", err)
+	}
+	return c
+}
+
+// Nodes returns the nodes of the cluster, in the order they were started
+func (c *Cluster) Nodes() []*Node { return slices.Clone(c.nodes) }
+
+// Node returns the i-th node of the cluster
+func (c *Cluster) Node(i int) *Node { return c.nodes[i] }
+
+// Running returns the nodes that are not stopped
+func (c *Cluster) Running() []*Node {
+	var running []*Node
+	for _, n := range c.nodes {
+		if n.Running() {
+			running = append(running, n)
+		}
+	}
+	return running
+}
+
+// Close stops every node and removes the temporary directory of the
+// cluster
+func (c *Cluster) Close() {
+	for _, n := range c.nodes {
+		n.Stop()
+	}
+	if c.tempDir {
+		_ = os.RemoveAll(c.dir)
+	}
+}
+
+// SetFaults replaces the message faults of every node
+func (c *Cluster) SetFaults(f Faults) error {
+	for _, n := range c.nodes {
+		if err := n.SetFaults(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Partition splits the cluster into groups of nodes, by index, that cannot
+// reach each other. Nodes left out of every group reach all others.
+func (c *Cluster) Partition(groups ...[]int) {
+	hosts := make([][]string, len(groups))
+	for i, group := range groups {
+		for _, n := range group {
+			hosts[i] = append(hosts[i], c.nodes[n].host)
+		}
+	}
+	c.mu.Lock()
+	c.partitions = groups
+	c.mu.Unlock()
+	c.network.Partition(hosts...)
+}
+
+// Heal lifts the partitions and reconnects the running nodes
+func (c *Cluster) Heal() {
+	c.mu.Lock()
+	c.partitions = nil
+	c.mu.Unlock()
+	c.network.Heal()
+	c.Reconnect()
+}
+
+// Reconnect dials again between the running nodes that are not connected
+// and can reach each other
+func (c *Cluster) Reconnect() {
+	running := c.Running()
+	for i, a := range running {
+		for _, b := range running[i+1:] {
+			if c.reachable(a, b) && !a.ConnectedTo(b) && !b.ConnectedTo(a) {
+				b.dial(a)
+			}
+		}
+	}
+}
+
+// reachable reports whether the current partitions let a and b connect
+func (c *Cluster) reachable(a, b *Node) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ga, gb := -1, -1
+	for i, group := range c.partitions {
+		if slices.Contains(group, a.index) {
+			ga = i
+		}
+		if slices.Contains(group, b.index) {
+			gb = i
+		}
+	}
+	return ga < 0 || gb < 0 || ga == gb
+}
+
+// WaitConnected waits until every running node is connected to every
+// other it can reach
+func (c *Cluster) WaitConnected(ctx context.Context) error {
+	err := c.Eventually(ctx, func() bool {
+		running := c.Running()
+		for _, a := range running {
+			for _, b := range running {
+				if a != b && c.reachable(a, b) && !a.ConnectedTo(b) {
+					return false
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("nodes did not connect: %w", err)
+	}
+	return nil
+}
+
+// WaitReplicated waits until at least copies running nodes hold key
+func (c *Cluster) WaitReplicated(ctx context.Context, key string, copies int) error {
+	err := c.Eventually(ctx, func() bool { return len(c.Holders(key)) >= copies })
+	if err != nil {
+		return fmt.Errorf("%s has %d of %d copies: %w", key, len(c.Holders(key)), copies, err)
+	}
+	return nil
+}
+
+// WaitDeleted waits until no running node holds key
+func (c *Cluster) WaitDeleted(ctx context.Context, key string) error {
+	err := c.Eventually(ctx, func() bool { return len(c.Holders(key)) == 0 })
+	if err != nil {
+		return fmt.Errorf("%s is still held by %d nodes: %w", key, len(c.Holders(key)), err)
+	}
+	return nil
+}
+
+// Holders returns the running nodes holding a copy of key
+func (c *Cluster) Holders(key string) []*Node {
+	var holders []*Node
+	for _, n := range c.Running() {
+		if n.Has(key) {
+			holders = append(holders, n)
+		}
+	}
+	return holders
+}
+
+// Eventually polls cond until it holds, failing with the error of ctx when
+// ctx is done first
+func (c *Cluster) Eventually(ctx context.Context, cond func() bool) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for !cond() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// errStopped is returned for operations on stopped nodes
+var errStopped = errors.New("testkit: node is stopped")
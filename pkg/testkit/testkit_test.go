@@ -0,0 +1,84 @@
+package testkit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testContext(t *testing.T) context.Context {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestClusterReplicatesFiles(t *testing.T) {
+	c := Start(t, Options{Nodes: 3})
+	ctx := testContext(t)
+
+	require.NoError(t, c.Node(0).StoreBytes(ctx, "report.txt", []byte("quarterly numbers")))
+	require.NoError(t, c.WaitReplicated(ctx, "report.txt", 3))
+
+	got, err := c.Node(2).Get(ctx, "report.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "quarterly numbers", string(got))
+}
+
+func TestClusterPartitionAndHeal(t *testing.T) {
+	c := Start(t, Options{Nodes: 3})
+	ctx := testContext(t)
+
+	c.Partition([]int{0, 1}, []int{2})
+	require.NoError(t, c.Eventually(ctx, func() bool {
+		return !c.Node(0).ConnectedTo(c.Node(2)) && !c.Node(2).ConnectedTo(c.Node(1))
+	}))
+	assert.True(t, c.Node(0).ConnectedTo(c.Node(1)), "nodes on the same side stay connected")
+
+	require.NoError(t, c.Node(0).StoreBytes(ctx, "split.txt", []byte("written during the partition")))
+	require.NoError(t, c.WaitReplicated(ctx, "split.txt", 2))
+	assert.False(t, c.Node(2).Has("split.txt"))
+
+	c.Heal()
+	require.NoError(t, c.WaitConnected(ctx))
+	got, err := c.Node(2).Get(ctx, "split.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "written during the partition", string(got))
+}
+
+func TestNodeStopAndRestart(t *testing.T) {
+	c := Start(t, Options{Nodes: 3})
+	ctx := testContext(t)
+
+	require.NoError(t, c.Node(0).StoreBytes(ctx, "kept.txt", []byte("survives restarts")))
+	require.NoError(t, c.WaitReplicated(ctx, "kept.txt", 3))
+
+	c.Node(1).Stop()
+	assert.False(t, c.Node(1).Running())
+	assert.ErrorIs(t, c.Node(1).StoreBytes(ctx, "x", nil), errStopped)
+	require.NoError(t, c.Eventually(ctx, func() bool { return !c.Node(0).ConnectedTo(c.Node(1)) }))
+	assert.Len(t, c.Holders("kept.txt"), 2)
+
+	require.NoError(t, c.Node(1).Restart())
+	require.NoError(t, c.WaitConnected(ctx))
+	assert.True(t, c.Node(1).Has("kept.txt"), "files stay on disk across restarts")
+}
+
+func TestClusterFaults(t *testing.T) {
+	c := Start(t, Options{Nodes: 2, Seed: 7})
+	ctx := testContext(t)
+
+	require.NoError(t, c.Node(1).SetFaults(Faults{Drop: 1}))
+	storeCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	_ = c.Node(0).StoreBytes(storeCtx, "lost.txt", []byte("never arrives"))
+	assert.False(t, c.Node(1).Has("lost.txt"), "node 1 drops every message")
+	assert.Positive(t, c.Node(1).Stats().Dropped)
+
+	assert.Error(t, c.SetFaults(Faults{Drop: 2}))
+	_, err := New(Options{Nodes: -1})
+	assert.Error(t, err)
+}
@@ -0,0 +1,55 @@
+// Command deny-list is an example PeerVault plugin: a content scanner
+// flagging files that contain any of a list of words. It is launched by
+// the server from the plugins section of its config:
+//
+//	plugins:
+//	  external:
+//	    - name: deny-list
+//	      path: /usr/lib/peervault/plugins/deny-list
+//	      args: ["-words", "confidential,internal only"]
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Skpow1234/Peervault/pkg/plugin"
+)
+
+// denyList flags content containing one of its words, ignoring case
+type denyList struct {
+	words [][]byte
+}
+
+func (d denyList) Scan(ctx context.Context, key string, data []byte) (string, error) {
+	lower := bytes.ToLower(data)
+	for _, word := range d.words {
+		if bytes.Contains(lower, word) {
+			return fmt.Sprintf("contains %q", word), nil
+		}
+	}
+	return "", nil
+}
+
+func main() {
+	words := flag.String("words", "", "Comma-separated words files must not contain")
+	flag.Parse()
+
+	var d denyList
+	for _, word := range strings.Split(*words, ",") {
+		if word = strings.TrimSpace(word); word != "" {
+			d.words = append(d.words, []byte(strings.ToLower(word)))
+		}
+	}
+	// Logged by the server
+	log.Printf("deny-list: flagging %d words", len(d.words))
+
+	err := plugin.Serve(plugin.Plugins{Name: "deny-list", Version: "1.0.0", Scanner: d})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
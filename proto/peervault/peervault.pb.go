@@ -19,11 +19,19 @@ type FileChunk struct {
 	Offset   int64  `json:"offset,omitempty"`
 	IsLast   bool   `json:"is_last,omitempty"`
 	Checksum string `json:"checksum,omitempty"`
+	// IfMatch and IfNoneMatch of the first chunk are the conditions of the
+	// upload, see FileRequest
+	IfMatch     []string `json:"if_match,omitempty"`
+	IfNoneMatch []string `json:"if_none_match,omitempty"`
 }
 
 // FileRequest represents a request for file operations
 type FileRequest struct {
 	Key string `json:"key,omitempty"`
+	// IfMatch lists ETags the file must have one of, IfNoneMatch ETags it
+	// must have none of; "*" matches any existing file
+	IfMatch     []string `json:"if_match,omitempty"`
+	IfNoneMatch []string `json:"if_none_match,omitempty"`
 }
 
 // FileResponse represents file metadata
@@ -67,6 +75,50 @@ type UpdateFileMetadataRequest struct {
 	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
+// BatchOperation is one operation of a batch; which fields apply depends
+// on Op
+type BatchOperation struct {
+	Op          string            `json:"op,omitempty"`
+	Key         string            `json:"key,omitempty"`
+	Content     []byte            `json:"content,omitempty"`
+	Url         string            `json:"url,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	From        string            `json:"from,omitempty"`
+	To          string            `json:"to,omitempty"`
+	Tenant      string            `json:"tenant,omitempty"`
+	Overwrite   bool              `json:"overwrite,omitempty"`
+	AddTags     []string          `json:"add_tags,omitempty"`
+	RemoveTags  []string          `json:"remove_tags,omitempty"`
+	Set         map[string]string `json:"set,omitempty"`
+	Remove      []string          `json:"remove,omitempty"`
+}
+
+// BatchRequest represents a batch of mixed file operations
+type BatchRequest struct {
+	Operations  []*BatchOperation `json:"operations,omitempty"`
+	Parallelism int32             `json:"parallelism,omitempty"`
+}
+
+// BatchResult represents the result of one operation of a batch
+type BatchResult struct {
+	Index int32         `json:"index,omitempty"`
+	Op    string        `json:"op,omitempty"`
+	Key   string        `json:"key,omitempty"`
+	Code  string        `json:"code,omitempty"`
+	Error string        `json:"error,omitempty"`
+	File  *FileResponse `json:"file,omitempty"`
+}
+
+// BatchResponse represents the results of a batch, in the order of its
+// operations
+type BatchResponse struct {
+	Results   []*BatchResult `json:"results,omitempty"`
+	Succeeded int32          `json:"succeeded,omitempty"`
+	Failed    int32          `json:"failed,omitempty"`
+}
+
 // DeleteFileResponse represents a response to file deletion
 type DeleteFileResponse struct {
 	Success bool   `json:"success,omitempty"`
@@ -153,6 +205,22 @@ type FileOperationEvent struct {
 	Metadata  map[string]string      `json:"metadata,omitempty"`
 }
 
+// WatchRequest starts a watch on the keys under a prefix, after the event
+// carrying token or from now on without one
+type WatchRequest struct {
+	Prefix string `json:"prefix,omitempty"`
+	Token  string `json:"token,omitempty"`
+}
+
+// WatchEvent represents a change to a watched key
+type WatchEvent struct {
+	Op        string                 `json:"op,omitempty"`
+	Key       string                 `json:"key,omitempty"`
+	Size      int64                  `json:"size,omitempty"`
+	Token     string                 `json:"token,omitempty"`
+	Timestamp *timestamppb.Timestamp `json:"timestamp,omitempty"`
+}
+
 // PeerEvent represents a peer event
 type PeerEvent struct {
 	EventType string                 `json:"event_type,omitempty"`
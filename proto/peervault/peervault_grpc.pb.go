@@ -26,6 +26,7 @@ const (
 	PeerVaultService_GetFile_FullMethodName              = "/peervault.PeerVaultService/GetFile"
 	PeerVaultService_DeleteFile_FullMethodName           = "/peervault.PeerVaultService/DeleteFile"
 	PeerVaultService_UpdateFileMetadata_FullMethodName   = "/peervault.PeerVaultService/UpdateFileMetadata"
+	PeerVaultService_BatchExecute_FullMethodName         = "/peervault.PeerVaultService/BatchExecute"
 	PeerVaultService_ListPeers_FullMethodName            = "/peervault.PeerVaultService/ListPeers"
 	PeerVaultService_GetPeer_FullMethodName              = "/peervault.PeerVaultService/GetPeer"
 	PeerVaultService_AddPeer_FullMethodName              = "/peervault.PeerVaultService/AddPeer"
@@ -38,6 +39,7 @@ const (
 	PeerVaultService_StreamPeerEvents_FullMethodName     = "/peervault.PeerVaultService/StreamPeerEvents"
 	PeerVaultService_StreamSystemEvents_FullMethodName   = "/peervault.PeerVaultService/StreamSystemEvents"
 	PeerVaultService_StreamHealthEvents_FullMethodName   = "/peervault.PeerVaultService/StreamHealthEvents"
+	PeerVaultService_Watch_FullMethodName                = "/peervault.PeerVaultService/Watch"
 	PeerVaultService_GetDetailedHealth_FullMethodName    = "/peervault.PeerVaultService/GetDetailedHealth"
 	PeerVaultService_GetComponentHealth_FullMethodName   = "/peervault.PeerVaultService/GetComponentHealth"
 	PeerVaultService_ForceHealthCheck_FullMethodName     = "/peervault.PeerVaultService/ForceHealthCheck"
@@ -55,6 +57,7 @@ type PeerVaultServiceClient interface {
 	GetFile(ctx context.Context, in *FileRequest, opts ...grpc.CallOption) (*FileResponse, error)
 	DeleteFile(ctx context.Context, in *FileRequest, opts ...grpc.CallOption) (*DeleteFileResponse, error)
 	UpdateFileMetadata(ctx context.Context, in *UpdateFileMetadataRequest, opts ...grpc.CallOption) (*FileResponse, error)
+	BatchExecute(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error)
 	// Peer operations
 	ListPeers(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ListPeersResponse, error)
 	GetPeer(ctx context.Context, in *PeerRequest, opts ...grpc.CallOption) (*PeerResponse, error)
@@ -70,6 +73,7 @@ type PeerVaultServiceClient interface {
 	StreamPeerEvents(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (PeerVaultService_StreamPeerEventsClient, error)
 	StreamSystemEvents(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (PeerVaultService_StreamSystemEventsClient, error)
 	StreamHealthEvents(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (PeerVaultService_StreamHealthEventsClient, error)
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (PeerVaultService_WatchClient, error)
 	// Advanced health operations
 	GetDetailedHealth(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*HealthResponse, error)
 	GetComponentHealth(ctx context.Context, in *ComponentHealthRequest, opts ...grpc.CallOption) (*ComponentHealthResponse, error)
@@ -189,6 +193,15 @@ func (c *peerVaultServiceClient) UpdateFileMetadata(ctx context.Context, in *Upd
 	return out, nil
 }
 
+func (c *peerVaultServiceClient) BatchExecute(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	out := new(BatchResponse)
+	err := c.cc.Invoke(ctx, PeerVaultService_BatchExecute_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *peerVaultServiceClient) ListPeers(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ListPeersResponse, error) {
 	out := new(ListPeersResponse)
 	err := c.cc.Invoke(ctx, PeerVaultService_ListPeers_FullMethodName, in, out, opts...)
@@ -355,6 +368,21 @@ func (c *peerVaultServiceClient) StreamHealthEvents(ctx context.Context, in *emp
 	return x, nil
 }
 
+func (c *peerVaultServiceClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (PeerVaultService_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_PeerVaultService_serviceDesc.Streams[6], PeerVaultService_Watch_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &peerVaultServiceWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
 func (c *peerVaultServiceClient) GetDetailedHealth(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*HealthResponse, error) {
 	out := new(HealthResponse)
 	err := c.cc.Invoke(ctx, PeerVaultService_GetDetailedHealth_FullMethodName, in, out, opts...)
@@ -443,6 +471,23 @@ func (x *peerVaultServiceStreamHealthEventsClient) Recv() (*HealthEvent, error)
 	return m, nil
 }
 
+type PeerVaultService_WatchClient interface {
+	Recv() (*WatchEvent, error)
+	grpc.ClientStream
+}
+
+type peerVaultServiceWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *peerVaultServiceWatchClient) Recv() (*WatchEvent, error) {
+	m := new(WatchEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // PeerVaultServiceServer is the server API for PeerVaultService service.
 type PeerVaultServiceServer interface {
 	// File operations
@@ -452,6 +497,7 @@ type PeerVaultServiceServer interface {
 	GetFile(context.Context, *FileRequest) (*FileResponse, error)
 	DeleteFile(context.Context, *FileRequest) (*DeleteFileResponse, error)
 	UpdateFileMetadata(context.Context, *UpdateFileMetadataRequest) (*FileResponse, error)
+	BatchExecute(context.Context, *BatchRequest) (*BatchResponse, error)
 	// Peer operations
 	ListPeers(context.Context, *emptypb.Empty) (*ListPeersResponse, error)
 	GetPeer(context.Context, *PeerRequest) (*PeerResponse, error)
@@ -467,6 +513,7 @@ type PeerVaultServiceServer interface {
 	StreamPeerEvents(*emptypb.Empty, PeerVaultService_StreamPeerEventsServer) error
 	StreamSystemEvents(*emptypb.Empty, PeerVaultService_StreamSystemEventsServer) error
 	StreamHealthEvents(*emptypb.Empty, PeerVaultService_StreamHealthEventsServer) error
+	Watch(*WatchRequest, PeerVaultService_WatchServer) error
 	// Advanced health operations
 	GetDetailedHealth(context.Context, *emptypb.Empty) (*HealthResponse, error)
 	GetComponentHealth(context.Context, *ComponentHealthRequest) (*ComponentHealthResponse, error)
@@ -498,6 +545,9 @@ func (UnimplementedPeerVaultServiceServer) DeleteFile(context.Context, *FileRequ
 func (UnimplementedPeerVaultServiceServer) UpdateFileMetadata(context.Context, *UpdateFileMetadataRequest) (*FileResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateFileMetadata not implemented")
 }
+func (UnimplementedPeerVaultServiceServer) BatchExecute(context.Context, *BatchRequest) (*BatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchExecute not implemented")
+}
 func (UnimplementedPeerVaultServiceServer) ListPeers(context.Context, *emptypb.Empty) (*ListPeersResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListPeers not implemented")
 }
@@ -534,6 +584,9 @@ func (UnimplementedPeerVaultServiceServer) StreamSystemEvents(*emptypb.Empty, Pe
 func (UnimplementedPeerVaultServiceServer) StreamHealthEvents(*emptypb.Empty, PeerVaultService_StreamHealthEventsServer) error {
 	return status.Errorf(codes.Unimplemented, "method StreamHealthEvents not implemented")
 }
+func (UnimplementedPeerVaultServiceServer) Watch(*WatchRequest, PeerVaultService_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
 func (UnimplementedPeerVaultServiceServer) GetDetailedHealth(context.Context, *emptypb.Empty) (*HealthResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetDetailedHealth not implemented")
 }
@@ -681,6 +734,24 @@ func _PeerVaultService_UpdateFileMetadata_Handler(srv interface{}, ctx context.C
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PeerVaultService_BatchExecute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PeerVaultServiceServer).BatchExecute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PeerVaultService_BatchExecute_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PeerVaultServiceServer).BatchExecute(ctx, req.(*BatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _PeerVaultService_ListPeers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(emptypb.Empty)
 	if err := dec(in); err != nil {
@@ -891,6 +962,14 @@ func (x *peerVaultServiceStreamSystemEventsServer) Send(m *SystemEvent) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _PeerVaultService_StreamHealthEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(emptypb.Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PeerVaultServiceServer).StreamHealthEvents(m, &peerVaultServiceStreamHealthEventsServer{stream})
+}
+
 type PeerVaultService_StreamHealthEventsServer interface {
 	Send(*HealthEvent) error
 	grpc.ServerStream
@@ -904,6 +983,27 @@ func (x *peerVaultServiceStreamHealthEventsServer) Send(m *HealthEvent) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _PeerVaultService_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PeerVaultServiceServer).Watch(m, &peerVaultServiceWatchServer{stream})
+}
+
+type PeerVaultService_WatchServer interface {
+	Send(*WatchEvent) error
+	grpc.ServerStream
+}
+
+type peerVaultServiceWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *peerVaultServiceWatchServer) Send(m *WatchEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _PeerVaultService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "peervault.PeerVaultService",
 	HandlerType: (*PeerVaultServiceServer)(nil),
@@ -924,6 +1024,10 @@ var _PeerVaultService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "UpdateFileMetadata",
 			Handler:    _PeerVaultService_UpdateFileMetadata_Handler,
 		},
+		{
+			MethodName: "BatchExecute",
+			Handler:    _PeerVaultService_BatchExecute_Handler,
+		},
 		{
 			MethodName: "ListPeers",
 			Handler:    _PeerVaultService_ListPeers_Handler,
@@ -983,6 +1087,16 @@ var _PeerVaultService_serviceDesc = grpc.ServiceDesc{
 			Handler:       _PeerVaultService_StreamSystemEvents_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "StreamHealthEvents",
+			Handler:       _PeerVaultService_StreamHealthEvents_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _PeerVaultService_Watch_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "peervault.proto",
 }
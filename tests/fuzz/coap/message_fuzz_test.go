@@ -0,0 +1,60 @@
+package coap
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/Skpow1234/Peervault/internal/api/coap"
+)
+
+// FuzzParseMessage tests CoAP message parsing with fuzz-generated data.
+// The encoding is canonical, so every message that parses encodes back to
+// the bytes it was parsed from.
+func FuzzParseMessage(f *testing.F) {
+	// Add seed corpus for CoAP message parsing testing
+	seedCorpus := [][]byte{
+		// Empty message (ping)
+		{0x40, 0x00, 0x12, 0x34},
+
+		// GET /files with a token
+		{0x42, 0x01, 0x12, 0x34, 0xAB, 0xCD, 0xB5, 'f', 'i', 'l', 'e', 's'},
+
+		// POST with Content-Format and a payload
+		{0x40, 0x02, 0x00, 0x01, 0xC1, 0x32, 0xFF, 'h', 'i'},
+
+		// Option with a 2-byte extended delta and length
+		{0x40, 0x01, 0x00, 0x01, 0xEE, 0x00, 0x00, 0x00, 0x00},
+
+		// Truncated token
+		{0x48, 0x01, 0x00, 0x01, 0x01},
+
+		// Payload marker without payload
+		{0x40, 0x02, 0x00, 0x01, 0xFF},
+
+		// Reserved option nibble
+		{0x40, 0x01, 0x00, 0x01, 0xF0},
+	}
+
+	for _, seed := range seedCorpus {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := coap.ParseMessage(data)
+		if err != nil {
+			if !errors.Is(err, coap.ErrMessageFormat) {
+				t.Fatalf("parse error %v does not wrap ErrMessageFormat", err)
+			}
+			return
+		}
+
+		encoded, err := msg.Encode()
+		if err != nil {
+			t.Fatalf("failed to encode parsed message: %v", err)
+		}
+		if !bytes.Equal(encoded, data) {
+			t.Fatalf("message %x encoded as %x", data, encoded)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package content
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/multiformats/go-multihash"
+
+	"github.com/Skpow1234/Peervault/internal/content"
+)
+
+// FuzzParseCID tests CID parsing with fuzz-generated strings
+func FuzzParseCID(f *testing.F) {
+	// Add seed corpus for CID parsing testing
+	seedCorpus := []string{
+		// Valid CIDv0 and CIDv1
+		"QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG",
+		"bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi",
+		"zdj7WWeQ43G6JJvLWQWZpyHuAMq6uYWRjkBXFad11vE2LHhQ7",
+
+		// Invalid CIDs
+		"",
+		"invalid-cid",
+		"Qm",
+		"bafy",
+		"b",
+		"z",
+	}
+
+	for _, seed := range seedCorpus {
+		f.Add(seed)
+	}
+
+	ca := content.NewContentAddresser()
+
+	f.Fuzz(func(t *testing.T, cidStr string) {
+		cid, err := ca.ParseCID(cidStr)
+		if validateErr := ca.ValidateCID(cidStr); (validateErr == nil) != (err == nil) {
+			t.Fatalf("ValidateCID and ParseCID disagree on %q: %v, %v", cidStr, validateErr, err)
+		}
+		if err != nil {
+			if cidStr != "" && !errors.Is(err, content.ErrInvalidCID) {
+				t.Fatalf("parse error %v does not wrap ErrInvalidCID", err)
+			}
+			return
+		}
+
+		// The hash of a CID is its multihash, in hex
+		mh, err := hex.DecodeString(cid.Hash)
+		if err != nil {
+			t.Fatalf("hash %q of %q is not hex: %v", cid.Hash, cidStr, err)
+		}
+		decoded, err := multihash.Decode(mh)
+		if err != nil {
+			t.Fatalf("hash %q of %q is not a multihash: %v", cid.Hash, cidStr, err)
+		}
+		if decoded.Name != cid.Algorithm || (cid.Version != 0 && cid.Version != 1) {
+			t.Fatalf("%q parsed to %+v", cidStr, cid)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package mqtt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Skpow1234/Peervault/internal/api/mqtt"
+)
+
+// maxPacketSize is the largest packet read, as a broker configured with a
+// MaxMessageSize of 64KB would
+const maxPacketSize = 64 * 1024
+
+// FuzzReadPacket tests MQTT packet reading and parsing with fuzz-generated
+// data
+func FuzzReadPacket(f *testing.F) {
+	// Add seed corpus for MQTT packet testing
+	seedCorpus := [][]byte{
+		// CONNECT of client c1 with a clean session
+		{0x10, 14, 0, 4, 'M', 'Q', 'T', 'T', 4, 0x02, 0, 60, 0, 2, 'c', '1'},
+
+		// CONNECT with a will, user name and password
+		{0x10, 27, 0, 4, 'M', 'Q', 'T', 'T', 4, 0xC6, 0, 60, 0, 1, 'c', 0, 1, 't', 0, 1, 'm', 0, 1, 'u', 0, 1, 'p'},
+
+		// QoS 1 PUBLISH with a payload
+		{0x32, 7, 0, 1, 'a', 0, 1, 'h', 'i'},
+
+		// SUBSCRIBE to a/# at QoS 1
+		{0x82, 8, 0, 1, 0, 3, 'a', '/', '#', 1},
+
+		// UNSUBSCRIBE from a/+
+		{0xA2, 7, 0, 1, 0, 3, 'a', '/', '+'},
+
+		// PINGREQ
+		{0xC0, 0},
+
+		// Remaining length longer than 4 bytes
+		{0x30, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F},
+
+		// Truncated packet
+		{0x30, 10, 0, 1},
+	}
+
+	for _, seed := range seedCorpus {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		packet, err := mqtt.ReadPacket(bytes.NewReader(data), maxPacketSize)
+		if err != nil {
+			if !errors.Is(err, mqtt.ErrMalformedPacket) && err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("read error %v is neither malformed nor truncated", err)
+			}
+			return
+		}
+		if len(packet.Data) > maxPacketSize || len(packet.Data) > len(data) {
+			t.Fatalf("packet of %d bytes read from %d bytes", len(packet.Data), len(data))
+		}
+
+		switch packet.Header.MessageType {
+		case mqtt.CONNECT:
+			connect, err := mqtt.ParseConnect(packet)
+			if err != nil {
+				checkMalformed(t, err)
+				return
+			}
+			if connect.WillQoS > byte(mqtt.QoS2) || (connect.PasswordFlag && !connect.UsernameFlag) {
+				t.Fatalf("invalid CONNECT accepted: %+v", connect)
+			}
+		case mqtt.PUBLISH:
+			publish, err := mqtt.ParsePublish(packet)
+			if err != nil {
+				checkMalformed(t, err)
+				return
+			}
+			if publish.QoS > mqtt.QoS2 || publish.Topic == "" || strings.ContainsAny(publish.Topic, "+#\x00") {
+				t.Fatalf("invalid PUBLISH accepted: %+v", publish)
+			}
+			if publish.QoS > mqtt.QoS0 && publish.PacketID == 0 {
+				t.Fatalf("PUBLISH without packet ID accepted: %+v", publish)
+			}
+		case mqtt.SUBSCRIBE:
+			subscribe, err := mqtt.ParseSubscribe(packet)
+			if err != nil {
+				checkMalformed(t, err)
+				return
+			}
+			if subscribe.PacketID == 0 || len(subscribe.Subscriptions) == 0 {
+				t.Fatalf("invalid SUBSCRIBE accepted: %+v", subscribe)
+			}
+			for _, subscription := range subscribe.Subscriptions {
+				if subscription.QoS > mqtt.QoS2 || subscription.Topic == "" {
+					t.Fatalf("invalid subscription accepted: %+v", subscription)
+				}
+			}
+		case mqtt.UNSUBSCRIBE:
+			unsubscribe, err := mqtt.ParseUnsubscribe(packet)
+			if err != nil {
+				checkMalformed(t, err)
+				return
+			}
+			if unsubscribe.PacketID == 0 || len(unsubscribe.Topics) == 0 {
+				t.Fatalf("invalid UNSUBSCRIBE accepted: %+v", unsubscribe)
+			}
+		}
+	})
+}
+
+// checkMalformed fails the test unless err wraps ErrMalformedPacket
+func checkMalformed(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, mqtt.ErrMalformedPacket) {
+		t.Fatalf("parse error %v does not wrap ErrMalformedPacket", err)
+	}
+}
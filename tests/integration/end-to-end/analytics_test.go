@@ -0,0 +1,45 @@
+package end_to_end
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Skpow1234/Peervault/internal/analytics"
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAccessAnalytics checks that a node counts the files written by its
+// clients under their key and the copies pushed by a peer under the peer.
+func TestAccessAnalytics(t *testing.T) {
+	start := func(addr string, bootstrap ...string) *fs.Server {
+		s := createTestServer(addr, bootstrap)
+		s.Analytics = analytics.NewCollector(analytics.Options{})
+		require.NoError(t, s.Start())
+		t.Cleanup(s.Stop)
+		return s
+	}
+	server1 := start(":3051")
+	server2 := start(":3052", ":3051")
+	require.Eventually(t, func() bool { return server1.Ring().Len() == 2 && server2.Ring().Len() == 2 }, 5*time.Second, 50*time.Millisecond)
+
+	key := fmt.Sprintf("report_%d.txt", time.Now().UnixNano())
+	require.NoError(t, server1.Store(context.Background(), key, strings.NewReader("quarterly numbers")))
+
+	rollups, err := server1.Analytics.Query(analytics.Query{Dimension: analytics.ByKey, Value: key})
+	require.NoError(t, err)
+	require.Len(t, rollups, 1)
+	assert.Equal(t, analytics.Counters{Writes: 1, BytesWritten: 17}, rollups[0].Counters)
+
+	require.Eventually(t, func() bool {
+		rollups, err := server2.Analytics.Query(analytics.Query{Dimension: analytics.ByPeer})
+		return err == nil && len(rollups) == 1 && rollups[0].Writes == 1
+	}, 5*time.Second, 50*time.Millisecond, "the copy pushed by server1 is counted under it")
+	rollups, err = server2.Analytics.Query(analytics.Query{Dimension: analytics.ByPeer})
+	require.NoError(t, err)
+	assert.Equal(t, int64(17), rollups[0].BytesWritten)
+}
@@ -0,0 +1,61 @@
+package end_to_end
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFileAttributes stores a file with tags and metadata on one node and
+// reads them back from the metadata store of both nodes holding it, then
+// changes them on the first node.
+func TestFileAttributes(t *testing.T) {
+	start := func(addr string, bootstrap ...string) *fs.Server {
+		s := createTestServer(addr, bootstrap)
+		s.Metadata = metadata.NewStore(metadata.StoreOpts{})
+		require.NoError(t, s.Start())
+		t.Cleanup(s.Stop)
+		return s
+	}
+	server1 := start(":3141")
+	server2 := start(":3142", ":3141")
+	require.Eventually(t, func() bool { return server1.Ring().Len() == 2 && server2.Ring().Len() == 2 }, 5*time.Second, 50*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	key := fmt.Sprintf("reports/attributes_%d.txt", time.Now().UnixNano())
+	err := server1.StoreWithAttributes(ctx, key, bytes.NewReader([]byte("quarterly numbers")), []string{"report"}, map[string]string{"project": "apollo"})
+	require.NoError(t, err)
+
+	rec, err := server1.Metadata.Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"report"}, rec.Tags)
+	assert.Equal(t, "apollo", rec.Metadata["project"])
+
+	// The replica records the file under the key it was stored with
+	require.Eventually(t, func() bool {
+		rec, err := server2.Metadata.Get(key)
+		return err == nil && assert.ObjectsAreEqual([]string{"report"}, rec.Tags)
+	}, 10*time.Second, 50*time.Millisecond)
+	rec, err = server2.Metadata.Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, key, rec.Key)
+	assert.Equal(t, crypto.HashKey(key), rec.HashedKey)
+	assert.Equal(t, "apollo", rec.Metadata["project"])
+
+	_, err = server1.Metadata.UpdateAttributes(key, metadata.AttributePatch{AddTags: []string{"final"}, Set: map[string]string{"project": "gemini"}})
+	require.NoError(t, err)
+	require.NoError(t, server1.ReplicateAttributes(key))
+	require.Eventually(t, func() bool {
+		rec, err := server2.Metadata.Get(key)
+		return err == nil && rec.Metadata["project"] == "gemini" && assert.ObjectsAreEqual([]string{"final", "report"}, rec.Tags)
+	}, 10*time.Second, 50*time.Millisecond)
+}
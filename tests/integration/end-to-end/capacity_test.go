@@ -0,0 +1,75 @@
+package end_to_end
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStorageFull fills a node of a three node cluster with two copies per
+// file and checks that it refuses new files and that its peers place new
+// copies on the other nodes.
+func TestStorageFull(t *testing.T) {
+	start := func(addr string, capacity int64, bootstrap ...string) *fs.Server {
+		s := createTestServer(addr, bootstrap)
+		s.ReplicationFactor = 2
+		s.Capacity = capacity
+		require.NoError(t, s.Storage().Clear())
+		require.NoError(t, s.Start())
+		t.Cleanup(s.Stop)
+		return s
+	}
+	server1 := start(":3101", 0)
+	server2 := start(":3102", 0, ":3101")
+	// Room for a single small file
+	small := start(":3103", 1<<10, ":3101", ":3102")
+	require.Eventually(t, func() bool { return server1.Ring().Len() == 3 && small.Ring().Len() == 3 }, 5*time.Second, 50*time.Millisecond)
+
+	// Nodes advertise the free space of their filesystem
+	for _, p := range server1.Peers() {
+		assert.Positive(t, p.Free, p.ID)
+		assert.False(t, p.Full, p.ID)
+	}
+	assert.False(t, small.Space().Full)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	require.NoError(t, small.Store(ctx, "capacity_fill.txt", bytes.NewReader(make([]byte, 1<<10))))
+	assert.True(t, small.Space().Full)
+	err := small.Store(ctx, "capacity_refused.txt", bytes.NewReader([]byte("no room")))
+	assert.ErrorIs(t, err, fs.ErrStorageFull)
+	assert.False(t, small.Storage().Has("capacity_refused.txt"))
+
+	// The peers learn that the node is full
+	require.Eventually(t, func() bool {
+		for _, p := range server1.Peers() {
+			if p.ID == small.ID {
+				return p.Full
+			}
+		}
+		return false
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// New files go to the other nodes, even those the full node owns. Its
+	// small weight gives it few files, so look for some it owns.
+	var keys []string
+	for i := 0; len(keys) < 5 && i < 100000; i++ {
+		key := fmt.Sprintf("capacity_%d_%d.txt", time.Now().UnixNano(), i)
+		if server1.Ring().Owns(small.ID, crypto.HashKey(key), 2) {
+			keys = append(keys, key)
+		}
+	}
+	require.NotEmpty(t, keys, "the full node owns some keys")
+	for _, key := range keys {
+		require.NoError(t, server1.Store(ctx, key, bytes.NewReader([]byte("content of "+key))))
+		assert.True(t, server2.Storage().Has(crypto.HashKey(key)), key)
+		assert.False(t, small.Storage().Has(crypto.HashKey(key)), key)
+	}
+}
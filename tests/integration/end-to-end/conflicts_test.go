@@ -0,0 +1,89 @@
+package end_to_end
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConflictingWrites stores the same key on two nodes before they know
+// each other. Once connected, each receives the other's version, keeps
+// both as a conflict, and converges on the version a user picks.
+func TestConflictingWrites(t *testing.T) {
+	server1 := createTestServer(":3031", nil)
+	server2 := createTestServer(":3032", nil)
+	for _, s := range []*fs.Server{server1, server2} {
+		require.NoError(t, s.Start())
+		t.Cleanup(s.Stop)
+	}
+	conflicts, unsubscribe := server1.Events.Subscribe(8)
+	defer unsubscribe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	read := func(r io.Reader, err error) string {
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	key := fmt.Sprintf("conflict_%d.txt", time.Now().UnixNano())
+	require.NoError(t, server1.Store(ctx, key, strings.NewReader("written on one")))
+	require.NoError(t, server2.Store(ctx, key, strings.NewReader("written on two")))
+
+	require.NoError(t, server2.Transport.Dial(":3031"))
+	for _, s := range []*fs.Server{server1, server2} {
+		require.Eventually(t, func() bool { return len(s.Conflicts()) == 1 }, 15*time.Second, 100*time.Millisecond)
+	}
+
+	select {
+	case e := <-conflicts:
+		assert.Equal(t, events.FileConflict, e.Type)
+		assert.Equal(t, key, e.Key)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no conflict event")
+	}
+
+	// Each node keeps serving its own version until the conflict is resolved
+	assert.Equal(t, "written on one", read(server1.Get(ctx, key)))
+	versions, err := server1.Versions(key)
+	require.NoError(t, err)
+	assert.Equal(t, key, versions.Key)
+	assert.Equal(t, crypto.HashKey(key), versions.HashedKey)
+	require.Len(t, versions.Versions, 2)
+	assert.True(t, versions.Versions[0].Current)
+	theirs := versions.Versions[1]
+	assert.False(t, theirs.Current)
+	assert.NotEmpty(t, theirs.From)
+	assert.Equal(t, "written on two", read(server1.ReadVersion(key, theirs.ID)))
+
+	_, err = server1.ResolveConflict(ctx, key, "unknown")
+	assert.ErrorIs(t, err, fs.ErrVersionNotFound)
+	resolved, err := server1.ResolveConflict(ctx, key, theirs.ID)
+	require.NoError(t, err)
+	assert.True(t, resolved.Vector.Descends(theirs.Vector))
+	assert.True(t, resolved.Vector.Descends(versions.Versions[0].Vector))
+
+	// The resolution replaces both copies
+	require.Eventually(t, func() bool { return len(server2.Conflicts()) == 0 }, 10*time.Second, 100*time.Millisecond)
+	assert.Empty(t, server1.Conflicts())
+	for _, s := range []*fs.Server{server1, server2} {
+		assert.Equal(t, "written on two", read(s.Get(ctx, key)))
+	}
+	versions, err = server2.Versions(key)
+	require.NoError(t, err)
+	require.Len(t, versions.Versions, 1)
+	assert.Equal(t, resolved.ID, versions.Versions[0].ID)
+	_, err = server1.ResolveConflict(ctx, key, resolved.ID)
+	assert.ErrorIs(t, err, fs.ErrNoConflict)
+}
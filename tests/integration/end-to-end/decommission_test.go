@@ -0,0 +1,112 @@
+package end_to_end
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/policy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDecommission decommissions a node holding files of a three node
+// cluster with two copies per file and checks that the remaining nodes
+// end up with both copies of each file.
+func TestDecommission(t *testing.T) {
+	start := func(addr string, bootstrap ...string) *fs.Server {
+		s := createTestServer(addr, bootstrap)
+		s.ReplicationFactor = 2
+		require.NoError(t, s.Start())
+		t.Cleanup(s.Stop)
+		return s
+	}
+	server1 := start(":3091")
+	server2 := start(":3092", ":3091")
+	server3 := start(":3093", ":3091", ":3092")
+	require.Eventually(t, func() bool { return server1.Ring().Len() == 3 && server3.Ring().Len() == 3 }, 5*time.Second, 50*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	keys := make([]string, 10)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("decommission_%d_%d.txt", time.Now().UnixNano(), i)
+		require.NoError(t, server3.Store(ctx, keys[i], bytes.NewReader([]byte("content of "+keys[i]))))
+	}
+
+	_, err := server3.AbortDecommission()
+	assert.ErrorIs(t, err, fs.ErrNotDecommissioning)
+
+	status, err := server3.Decommission()
+	require.NoError(t, err)
+	assert.Equal(t, fs.DecommissionEvacuating, status.Phase)
+	_, err = server3.Decommission()
+	assert.ErrorIs(t, err, fs.ErrDecommissioning)
+
+	// The peers take the node off their rings at once
+	require.Eventually(t, func() bool {
+		return !server1.Ring().Has(server3.ID) && !server2.Ring().Has(server3.ID)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		return server3.DecommissionStatus().Phase == fs.DecommissionCompleted
+	}, 20*time.Second, 100*time.Millisecond)
+	status = server3.DecommissionStatus()
+	assert.Equal(t, len(keys), status.Files)
+	assert.Equal(t, len(keys), status.Evacuated)
+	assert.Zero(t, status.Pending)
+
+	// Both remaining nodes own every file now
+	for _, key := range keys {
+		for _, s := range []*fs.Server{server1, server2} {
+			assert.True(t, s.Storage().Has(crypto.HashKey(key)), "%s on %s", key, s.ID)
+		}
+		assert.True(t, server3.Storage().Has(key), "local copies are kept")
+	}
+
+	// The node left: it disconnected from its peers
+	require.Eventually(t, func() bool { return len(server3.Peers()) == 0 }, 5*time.Second, 50*time.Millisecond)
+	_, err = server3.Decommission()
+	assert.ErrorIs(t, err, fs.ErrDecommissioning)
+}
+
+// TestDecommissionAbort aborts a decommission that cannot complete, as the
+// policy of the node denies every replica, and checks the node is back on
+// its peer's ring.
+func TestDecommissionAbort(t *testing.T) {
+	server1 := createTestServer(":3094", nil)
+	require.NoError(t, server1.Start())
+	t.Cleanup(server1.Stop)
+
+	engine, err := policy.New(policy.Options{Policy: policy.Policy{Rules: []policy.Rule{
+		{ID: "no-replicas", Operations: []policy.Operation{policy.OpReplicate}, Deny: "true"},
+	}}})
+	require.NoError(t, err)
+	server2 := createTestServer(":3095", []string{":3094"})
+	server2.Policy = engine
+	require.NoError(t, server2.Start())
+	t.Cleanup(server2.Stop)
+	require.Eventually(t, func() bool { return server1.Ring().Len() == 2 }, 5*time.Second, 50*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	key := fmt.Sprintf("decommission_abort_%d.txt", time.Now().UnixNano())
+	require.NoError(t, server2.Store(ctx, key, bytes.NewReader([]byte("stays here"))))
+
+	_, err = server2.Decommission()
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return !server1.Ring().Has(server2.ID) }, 5*time.Second, 50*time.Millisecond)
+	require.Eventually(t, func() bool { return server2.DecommissionStatus().Pending == 1 }, 10*time.Second, 50*time.Millisecond)
+	assert.Contains(t, server2.DecommissionStatus().LastError, "no-replicas")
+
+	status, err := server2.AbortDecommission()
+	require.NoError(t, err)
+	assert.Equal(t, fs.DecommissionAborted, status.Phase)
+	assert.False(t, status.FinishedAt.IsZero())
+	require.Eventually(t, func() bool { return server1.Ring().Has(server2.ID) }, 5*time.Second, 50*time.Millisecond)
+	assert.Len(t, server2.Peers(), 1, "the node stays connected")
+}
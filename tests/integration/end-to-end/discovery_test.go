@@ -0,0 +1,81 @@
+package end_to_end
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/rendezvous"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/seeds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConnectDiscovered connects two nodes that found each other, as mDNS
+// reports them, without bootstrap nodes.
+func TestConnectDiscovered(t *testing.T) {
+	server1 := createTestServer(":3071", nil)
+	server2 := createTestServer(":3072", nil)
+	require.NoError(t, server1.Start())
+	t.Cleanup(server1.Stop)
+	require.NoError(t, server2.Start())
+	t.Cleanup(server2.Stop)
+
+	// Only the node with the lower ID dials
+	low, high, highAddr := server1, server2, ":3072"
+	if server2.ID < server1.ID {
+		low, high, highAddr = server2, server1, ":3071"
+	}
+	assert.False(t, high.ConnectDiscovered(low.ID, ":0"))
+	assert.False(t, low.ConnectDiscovered(low.ID, ":0"))
+	require.True(t, low.ConnectDiscovered(high.ID, highAddr))
+	require.Eventually(t, func() bool {
+		return low.Ring().Has(high.ID) && high.Ring().Has(low.ID)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// Connected peers are not dialed again
+	assert.False(t, low.ConnectDiscovered(high.ID, highAddr))
+}
+
+// staticSeeds lists fixed peers
+type staticSeeds []seeds.Peer
+
+func (s staticSeeds) Peers(ctx context.Context) ([]seeds.Peer, error) { return s, nil }
+
+// TestSeedsAndRendezvous forms a cluster of nodes that only know a
+// rendezvous service, and adds a node that only knows the address of one
+// of them, as DNS seeds list it.
+func TestSeedsAndRendezvous(t *testing.T) {
+	service := httptest.NewServer(rendezvous.NewServer(rendezvous.ServerOptions{}))
+	defer service.Close()
+
+	var servers []*fs.Server
+	for _, addr := range []string{":3073", ":3074", ":3075"} {
+		s := createTestServer(addr, nil)
+		s.Seeds = []seeds.Source{&rendezvous.Client{URL: service.URL, NodeID: s.ID, Addr: addr, Cluster: "e2e"}}
+		s.SeedInterval = 100 * time.Millisecond
+		require.NoError(t, s.Start())
+		t.Cleanup(s.Stop)
+		servers = append(servers, s)
+	}
+	joined := func(n int) bool {
+		for _, s := range servers {
+			if s.Ring().Len() != n {
+				return false
+			}
+		}
+		return true
+	}
+	require.Eventually(t, func() bool { return joined(3) }, 10*time.Second, 50*time.Millisecond)
+
+	// A seed listing the new node itself is skipped
+	s := createTestServer(":3076", nil)
+	s.Seeds = []seeds.Source{staticSeeds{{Addr: ":3076"}, {Addr: ":3073"}}}
+	s.SeedInterval = 100 * time.Millisecond
+	require.NoError(t, s.Start())
+	t.Cleanup(s.Stop)
+	require.Eventually(t, func() bool { return s.Ring().Len() == 2 }, 10*time.Second, 50*time.Millisecond)
+	assert.True(t, s.Ring().Has(servers[0].ID))
+}
@@ -0,0 +1,82 @@
+package end_to_end
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/crdt"
+	"github.com/Skpow1234/Peervault/internal/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSharedDocuments updates a shared document on two nodes at once and
+// checks that both end up with every change, and that a node joining later
+// catches up through gossip.
+func TestSharedDocuments(t *testing.T) {
+	start := func(addr string, bootstrap ...string) *fs.Server {
+		s := createTestServer(addr, bootstrap)
+		s.DocumentGossipInterval = 100 * time.Millisecond
+		require.NoError(t, s.Start())
+		t.Cleanup(s.Stop)
+		return s
+	}
+	server1 := start(":3041")
+	server2 := start(":3042", ":3041")
+	require.Eventually(t, func() bool { return server1.Ring().Len() == 2 && server2.Ring().Len() == 2 }, 5*time.Second, 50*time.Millisecond)
+	updates, unsubscribe := server2.Events.Subscribe(16)
+	defer unsubscribe()
+
+	_, err := server1.UpdateDocument("folder", crdt.Update{
+		Set: map[string]json.RawMessage{"owner": json.RawMessage(`"ana"`)},
+		Add: map[string][]string{"files": {"a.txt", "b.txt"}},
+	})
+	require.NoError(t, err)
+	_, err = server2.UpdateDocument("folder", crdt.Update{
+		Set: map[string]json.RawMessage{"color": json.RawMessage(`"blue"`)},
+		Add: map[string][]string{"files": {"c.txt"}},
+	})
+	require.NoError(t, err)
+
+	converged := func(servers ...*fs.Server) func() bool {
+		return func() bool {
+			want, err := server1.Document("folder")
+			if err != nil || len(want.Sets["files"]) != 3 || len(want.Fields) != 2 {
+				return false
+			}
+			for _, s := range servers {
+				got, err := s.Document("folder")
+				if err != nil || !assert.ObjectsAreEqual(want, got) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	require.Eventually(t, converged(server2), 5*time.Second, 50*time.Millisecond)
+	select {
+	case e := <-updates:
+		assert.Equal(t, events.DocumentUpdated, e.Type)
+		assert.Equal(t, "folder", e.Key)
+	case <-time.After(time.Second):
+		t.Fatal("no document event")
+	}
+
+	_, err = server2.UpdateDocument("folder", crdt.Update{Remove: map[string][]string{"files": {"a.txt"}}})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		view, err := server1.Document("folder")
+		return err == nil && assert.ObjectsAreEqual([]string{"b.txt", "c.txt"}, view.Sets["files"])
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// Updates are only pushed when they are made; a node joining later gets
+	// the document by gossip
+	server3 := start(":3043", ":3041")
+	require.Eventually(t, func() bool {
+		view, err := server3.Document("folder")
+		return err == nil && assert.ObjectsAreEqual([]string{"b.txt", "c.txt"}, view.Sets["files"])
+	}, 10*time.Second, 50*time.Millisecond)
+	assert.Equal(t, []string{"folder"}, server3.Documents())
+}
@@ -0,0 +1,128 @@
+package end_to_end
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/edge"
+	"github.com/Skpow1234/Peervault/internal/storage"
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+)
+
+// fakeOrigin is an upstream cluster whose files change under the edge
+type fakeOrigin struct {
+	mu      sync.Mutex
+	files   map[string]string
+	down    bool
+	fetches int
+}
+
+func (o *fakeOrigin) Fetch(ctx context.Context, key, etag string) ([]byte, string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.fetches++
+	if o.down {
+		return nil, "", errors.New("connection refused")
+	}
+	data, ok := o.files[key]
+	if !ok {
+		return nil, "", edge.ErrOriginNotFound
+	}
+	if etag == `"`+data+`"` {
+		return nil, etag, edge.ErrNotModified
+	}
+	return []byte(data), `"` + data + `"`, nil
+}
+
+func (o *fakeOrigin) set(f func(o *fakeOrigin)) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	f(o)
+}
+
+// TestEdgePullThrough checks that an edge node pulls missing files from
+// its origin, serves them locally until their TTL runs out, and then
+// follows the origin's changes
+func TestEdgePullThrough(t *testing.T) {
+	t.Chdir(t.TempDir())
+	origin := &fakeOrigin{files: map[string]string{"assets/app.js": "v1"}}
+	const ttl = 100 * time.Millisecond
+	pulls, err := edge.NewPullThrough(edge.PullOptions{Origin: origin, TTL: ttl, Prefixes: []string{"assets/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := fs.New(fs.Options{
+		EncKey:            crypto.NewEncryptionKey(),
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		Edge:              pulls,
+	})
+	ctx := context.Background()
+
+	read := func(key string) (string, error) {
+		t.Helper()
+		r, err := node.Get(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		data, _ := io.ReadAll(r)
+		return string(data), nil
+	}
+	expect := func(want string, fetches int) {
+		t.Helper()
+		got, err := read("assets/app.js")
+		if err != nil || got != want {
+			t.Fatalf("Expected %q, got %q (%v)", want, got, err)
+		}
+		if origin.fetches != fetches {
+			t.Fatalf("Expected %d fetches from the origin, got %d", fetches, origin.fetches)
+		}
+	}
+
+	// A miss is pulled, then served locally
+	expect("v1", 1)
+	expect("v1", 1)
+	if !node.Storage().Has("assets/app.js") {
+		t.Fatal("Expected the pulled file to be kept")
+	}
+
+	// Once stale, an unchanged file is revalidated, a changed one replaced
+	time.Sleep(ttl)
+	expect("v1", 2)
+	expect("v1", 2)
+	origin.set(func(o *fakeOrigin) { o.files["assets/app.js"] = "v2" })
+	time.Sleep(ttl)
+	expect("v2", 3)
+
+	// A stale copy is served while the origin is down
+	origin.set(func(o *fakeOrigin) { o.down = true })
+	time.Sleep(ttl)
+	expect("v2", 4)
+
+	// A file deleted at the origin goes away
+	origin.set(func(o *fakeOrigin) { o.down = false; delete(o.files, "assets/app.js") })
+	if _, err := read("assets/app.js"); err == nil {
+		t.Fatal("Expected the file deleted at the origin to be gone")
+	}
+	if node.Storage().Has("assets/app.js") {
+		t.Error("Expected the local copy to be deleted")
+	}
+
+	// Keys outside the prefixes are not pulled
+	origin.set(func(o *fakeOrigin) { o.files["private/key"] = "secret" })
+	if _, err := read("private/key"); err == nil {
+		t.Error("Expected keys outside the prefixes not to be pulled")
+	}
+
+	metrics := node.Metrics()
+	if metrics["edge_pulls_total"] != 2 || metrics["edge_revalidations_total"] != 1 || metrics["edge_stale_served_total"] != 1 {
+		t.Errorf("Unexpected edge metrics: %v", metrics)
+	}
+}
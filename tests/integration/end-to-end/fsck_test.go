@@ -0,0 +1,60 @@
+package end_to_end
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/storage"
+	"github.com/Skpow1234/Peervault/pkg/testkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFsckRepairsFromPeers breaks the copies of a file on two nodes, one
+// truncated and one lost, and checks fsck refetches both from the third
+func TestFsckRepairsFromPeers(t *testing.T) {
+	c := testkit.Start(t, testkit.Options{Nodes: 3, ReplicationFactor: 3})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	key := "ledger.csv"
+	data := []byte("date,amount\n2026-01-01,100\n2026-01-02,250\n")
+	require.NoError(t, c.Node(0).StoreBytes(ctx, key, data))
+	require.NoError(t, c.WaitReplicated(ctx, key, 3))
+
+	truncated, lost := c.Node(1), c.Node(2)
+	storageKey := crypto.HashKey(key)
+	location := truncated.Server().Storage().Locations(storageKey)[0]
+	require.NoError(t, os.Truncate(filepath.Join(truncated.Dir(), filepath.FromSlash(location)), 20))
+	require.NoError(t, lost.Server().Storage().Delete(storageKey))
+
+	report, err := truncated.Server().Fsck(ctx, false)
+	require.NoError(t, err)
+	require.Len(t, report.Findings, 1)
+	assert.Equal(t, storage.ProblemCorrupt, report.Findings[0].Problem)
+	assert.Equal(t, location, report.Findings[0].Location)
+	assert.Equal(t, storageKey, report.Findings[0].HashedKey)
+	assert.Equal(t, 1, report.Unrepaired)
+
+	for _, node := range []*testkit.Node{truncated, lost} {
+		report, err := node.Server().Fsck(ctx, true)
+		require.NoError(t, err)
+		require.Len(t, report.Findings, 1)
+		assert.Equal(t, fs.RepairRefetched, report.Findings[0].Repair, report.Findings[0].RepairError)
+		assert.Zero(t, report.Unrepaired)
+
+		got, err := node.Get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, data, got)
+
+		report, err = node.Server().Fsck(ctx, false)
+		require.NoError(t, err)
+		assert.Empty(t, report.Findings)
+	}
+	assert.DirExists(t, filepath.Join(truncated.Dir(), storage.QuarantineDirName), "the truncated copy is kept aside")
+}
@@ -0,0 +1,47 @@
+package end_to_end
+
+import (
+	"testing"
+	"time"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/geo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGeoNodes checks that nodes learn where their peers are, so each can
+// send clients to the node nearest to them
+func TestGeoNodes(t *testing.T) {
+	start := func(addr, region string, location geo.Point, url string, bootstrap ...string) *fs.Server {
+		s := createTestServer(addr, bootstrap)
+		s.Region, s.Location, s.PublicURL = region, &location, url
+		require.NoError(t, s.Storage().Clear())
+		require.NoError(t, s.Start())
+		t.Cleanup(s.Stop)
+		return s
+	}
+	dublin := start(":3111", "eu-west", geo.Point{Lat: 53.35, Lon: -6.26}, "https://eu.example.com")
+	virginia := start(":3112", "us-east", geo.Point{Lat: 38.9, Lon: -77.04}, "https://us.example.com", ":3111")
+
+	var nodes []geo.Node
+	require.Eventually(t, func() bool {
+		nodes = dublin.Nodes()
+		return len(nodes) == 2
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.True(t, nodes[0].Self)
+	peer := nodes[1]
+	assert.Equal(t, virginia.ID, peer.ID)
+	assert.Equal(t, "us-east", peer.Region)
+	assert.Equal(t, "https://us.example.com", peer.URL)
+	assert.True(t, peer.HasPoint)
+
+	// A client in Boston is sent to Virginia, one in Paris stays in Dublin
+	boston := geo.Location{Point: geo.Point{Lat: 42.36, Lon: -71.06}, HasPoint: true}
+	choice, ok := geo.Nearest(boston, true, nodes)
+	require.True(t, ok)
+	assert.Equal(t, virginia.ID, choice.Node.ID)
+	paris := geo.Location{Point: geo.Point{Lat: 48.85, Lon: 2.35}, HasPoint: true}
+	choice, _ = geo.Nearest(paris, true, nodes)
+	assert.True(t, choice.Node.Self)
+}
@@ -0,0 +1,72 @@
+package end_to_end
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/join"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withSecret makes the handshake of s sign with secret
+func withSecret(s *fs.Server, secret string, opts netp2p.HandshakeOptions) {
+	opts.Secret = secret
+	s.Transport.(*netp2p.TCPTransport).HandshakeFunc = netp2p.NewHandshakeFunc(s.ID, opts)
+}
+
+// TestJoinToken admits a node presenting a join token to a cluster with a
+// secret of its own, which nodes knowing only its address cannot join.
+func TestJoinToken(t *testing.T) {
+	const secret = "e2e-cluster-secret"
+	server1 := createTestServer(":3081", nil)
+	registry, err := join.New(join.Options{
+		NodeID: server1.ID,
+		Addrs:  []string{"127.0.0.1:3081"},
+		Credentials: func() join.Credentials {
+			return join.Credentials{Secret: secret, Peers: []string{"127.0.0.1:3081"}}
+		},
+	})
+	require.NoError(t, err)
+	server1.JoinTokens = registry
+	withSecret(server1, secret, netp2p.HandshakeOptions{Join: registry.Admit})
+	require.NoError(t, server1.Start())
+	t.Cleanup(server1.Stop)
+
+	_, token, err := registry.Create(context.Background(), join.CreateOptions{TTL: time.Minute, MaxUses: 1}, "e2e")
+	require.NoError(t, err)
+
+	// The new node joins with the token, then connects with the credentials
+	server2 := createTestServer(":3082", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	creds, err := join.Join(ctx, token.String(), server2.ID, nil)
+	require.NoError(t, err)
+	assert.Equal(t, server1.ID, creds.Issuer)
+	server2.BootstrapNodes = creds.Peers
+	withSecret(server2, creds.Secret, netp2p.HandshakeOptions{})
+	require.NoError(t, server2.Start())
+	t.Cleanup(server2.Stop)
+	require.Eventually(t, func() bool {
+		return server1.Ring().Has(server2.ID) && server2.Ring().Has(server1.ID)
+	}, 10*time.Second, 50*time.Millisecond)
+
+	records := registry.Tokens()
+	require.Len(t, records, 1)
+	require.Len(t, records[0].Joined, 1)
+	assert.Equal(t, server2.ID, records[0].Joined[0].NodeID)
+
+	// A node knowing the address but not the credentials stays out, and
+	// the used token admits nobody else
+	server3 := createTestServer(":3083", []string{"127.0.0.1:3081"})
+	require.NoError(t, server3.Start())
+	t.Cleanup(server3.Stop)
+	_, err = join.Join(ctx, token.String(), server3.ID, nil)
+	assert.ErrorIs(t, err, join.ErrRefused)
+	time.Sleep(500 * time.Millisecond)
+	assert.False(t, server1.Ring().Has(server3.ID))
+	assert.Equal(t, 1, server3.Ring().Len())
+}
@@ -0,0 +1,90 @@
+package end_to_end
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+	"github.com/Skpow1234/Peervault/internal/upgrade"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createLayoutTestServer(listenAddr, root, versionsPath string) *fs.Server {
+	transport := netp2p.NewTCPTransport(netp2p.TCPTransportOpts{
+		ListenAddr:    listenAddr,
+		HandshakeFunc: netp2p.NOPHandshakeFunc,
+		Decoder:       netp2p.LengthPrefixedDecoder{},
+	})
+	s := fs.New(fs.Options{
+		EncKey:       crypto.NewEncryptionKey(),
+		StorageRoot:  root,
+		Transport:    transport,
+		VersionsPath: versionsPath,
+	})
+	transport.OnPeer = s.OnPeer
+	return s
+}
+
+// TestStartUpgradesLayout starts a node on the layout of a release that
+// predates versioning, and checks it is upgraded with a backup taken, and
+// that a node refuses a layout newer than it knows
+func TestStartUpgradesLayout(t *testing.T) {
+	root := t.TempDir()
+	versionsPath := filepath.Join(t.TempDir(), "versions.json")
+	key := "report.txt"
+	legacy := `{"` + crypto.HashKey(key) + `":{"key":"report.txt","current":{"vector":{"node-a":2},"storage_key":"report.txt","size":5,"written_at":"2026-01-01T00:00:00Z"}}}`
+	require.NoError(t, os.WriteFile(versionsPath, []byte(legacy), 0600))
+
+	server := createLayoutTestServer(":3141", root, versionsPath)
+	report, err := server.UpgradeLayout(true)
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.From)
+	assert.Len(t, report.Steps, 2)
+	assert.NoFileExists(t, filepath.Join(root, upgrade.VersionFileName), "a dry run changes nothing")
+
+	require.NoError(t, server.Start())
+	server.Stop()
+	version, err := upgrade.Version(root)
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+	assert.FileExists(t, filepath.Join(root, "FORMAT"))
+
+	var index struct {
+		Format int                        `json:"format"`
+		Keys   map[string]json.RawMessage `json:"keys"`
+	}
+	data, err := os.ReadFile(versionsPath)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &index))
+	assert.Equal(t, 1, index.Format)
+	assert.Contains(t, index.Keys, crypto.HashKey(key))
+
+	backups, err := filepath.Glob(filepath.Join(root, upgrade.BackupDirName, "0-to-2-*", "versions.json"))
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	backedUp, err := os.ReadFile(backups[0])
+	require.NoError(t, err)
+	assert.Equal(t, legacy, string(backedUp))
+
+	// The upgraded index is read back, and nothing is left to upgrade
+	server = createLayoutTestServer(":3142", root, versionsPath)
+	report, err = server.UpgradeLayout(true)
+	require.NoError(t, err)
+	assert.Empty(t, report.Steps)
+	require.NoError(t, server.Start())
+	versions, err := server.Versions(key)
+	server.Stop()
+	require.NoError(t, err)
+	require.Len(t, versions.Versions, 1)
+	assert.Equal(t, uint64(2), versions.Versions[0].Vector["node-a"])
+
+	// A release older than the layout refuses to start
+	require.NoError(t, os.WriteFile(filepath.Join(root, upgrade.VersionFileName), []byte(`{"version":3}`), 0644))
+	err = createLayoutTestServer(":3143", root, versionsPath).Start()
+	assert.ErrorIs(t, err, upgrade.ErrTooNew)
+}
@@ -0,0 +1,70 @@
+package end_to_end
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestApplicationMessaging sends messages between the applications of two
+// nodes, one of them before the recipient joined, and checks the receipts.
+func TestApplicationMessaging(t *testing.T) {
+	start := func(addr string, bootstrap ...string) *fs.Server {
+		s := createTestServer(addr, bootstrap)
+		s.Messaging = messaging.New(messaging.Options{})
+		require.NoError(t, s.Start())
+		t.Cleanup(s.Stop)
+		return s
+	}
+	server1 := start(":3121")
+	receipts, stop := server1.Messaging.Receipts(16)
+	defer stop()
+
+	// The node is not connected yet, so the message waits in the outbox
+	server2 := createTestServer(":3122", []string{":3121"})
+	server2.Messaging = messaging.New(messaging.Options{})
+	d, err := server1.Messaging.Send(server2.ID, "jobs", []byte("job 1"), time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, messaging.Pending, d.Status)
+
+	require.NoError(t, server2.Start())
+	t.Cleanup(server2.Stop)
+	require.Eventually(t, func() bool {
+		d, err := server1.Messaging.Status(d.ID)
+		return err == nil && d.Status == messaging.Queued
+	}, 5*time.Second, 50*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m, err := server2.Messaging.Receive(ctx, "jobs")
+	require.NoError(t, err)
+	assert.Equal(t, "job 1", string(m.Payload))
+	assert.Equal(t, server1.ID, m.From)
+
+	for _, want := range []messaging.Status{messaging.Queued, messaging.Delivered} {
+		select {
+		case r := <-receipts:
+			assert.Equal(t, d.ID, r.MessageID)
+			assert.Equal(t, want, r.Status)
+			assert.Equal(t, server2.ID, r.Node)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s receipt", want)
+		}
+	}
+
+	// Replies travel the other way
+	reply, err := server2.Messaging.Send(server1.ID, "jobs/done", []byte("job 1 done"), 0)
+	require.NoError(t, err)
+	m, err = server1.Messaging.Receive(ctx, "jobs/done")
+	require.NoError(t, err)
+	assert.Equal(t, reply.ID, m.ID)
+	require.Eventually(t, func() bool {
+		d, err := server2.Messaging.Status(reply.ID)
+		return err == nil && d.Status == messaging.Delivered
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,69 @@
+package end_to_end
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/cache"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/storage"
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+)
+
+// TestReadCache checks that hot files are served from the read cache and
+// that writes and deletes on the node invalidate it
+func TestReadCache(t *testing.T) {
+	t.Chdir(t.TempDir())
+	readCache, err := cache.NewByteCache[[]byte](1<<20, cache.PolicyARC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := fs.New(fs.Options{
+		EncKey:            crypto.NewEncryptionKey(),
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		ReadCache:         readCache,
+	})
+	ctx := context.Background()
+
+	read := func() string {
+		t.Helper()
+		r, err := node.Get(ctx, "hot.txt")
+		if err != nil {
+			t.Fatalf("Failed to get file: %v", err)
+		}
+		data, _ := io.ReadAll(r)
+		return string(data)
+	}
+
+	if err := node.Store(ctx, "hot.txt", strings.NewReader("first")); err != nil {
+		t.Fatalf("Failed to store file: %v", err)
+	}
+	for range 3 {
+		if got := read(); got != "first" {
+			t.Fatalf("Expected first, got %q", got)
+		}
+	}
+	if stats := readCache.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("Expected 2 hits and 1 miss, got %+v", stats)
+	}
+
+	if err := node.Delete(ctx, "hot.txt"); err != nil {
+		t.Fatalf("Failed to delete file: %v", err)
+	}
+	if err := node.Store(ctx, "hot.txt", strings.NewReader("second")); err != nil {
+		t.Fatalf("Failed to store file: %v", err)
+	}
+	if got := read(); got != "second" {
+		t.Errorf("Expected the cache to drop the old content, got %q", got)
+	}
+
+	metrics := node.Metrics()
+	if metrics["read_cache_hit_ratio"] != 0.5 || metrics["read_cache_bytes"] != float64(len("second")) {
+		t.Errorf("Unexpected read cache metrics: %v", metrics)
+	}
+}
@@ -0,0 +1,72 @@
+package end_to_end
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReplicaStatus checks the copies of a file that three nodes report.
+// The copies are written straight into each node's store so the test does
+// not depend on replication itself.
+func TestReplicaStatus(t *testing.T) {
+	server1 := createTestServer(":3011", []string{})
+	server2 := createTestServer(":3012", []string{":3011"})
+	server3 := createTestServer(":3013", []string{":3011"})
+	for _, s := range []*fs.Server{server1, server2, server3} {
+		require.NoError(t, s.Start())
+		defer s.Stop()
+	}
+
+	key := fmt.Sprintf("replicas_%d.txt", time.Now().UnixNano())
+	data := []byte("three copies of this file, one of them truncated")
+	_, err := server1.Storage().Write(key, bytes.NewReader(data))
+	require.NoError(t, err)
+	// Peers keep files under the hashed key
+	_, err = server2.Storage().Write(crypto.HashKey(key), bytes.NewReader(data))
+	require.NoError(t, err)
+	_, err = server3.Storage().Write(crypto.HashKey(key), bytes.NewReader(data[:10]))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Wait until both peers are connected and answer
+	var report fs.ReplicaReport
+	require.Eventually(t, func() bool {
+		report, err = server1.Replicas(ctx, key)
+		return err == nil && len(report.Replicas) == 3
+	}, 10*time.Second, 100*time.Millisecond)
+	assert.Equal(t, fs.DefaultReplicationFactor, report.ReplicationFactor)
+	statuses := replicaStatuses(report)
+	assert.Equal(t, map[string]fs.ReplicaStatus{
+		server1.ID: fs.ReplicaHealthy,
+		server2.ID: fs.ReplicaHealthy,
+		server3.ID: fs.ReplicaSizeMismatch,
+	}, statuses)
+	assert.True(t, report.Replicas[0].Local, "the local copy comes first")
+	assert.Equal(t, 2, report.Healthy)
+	assert.False(t, report.Satisfied)
+
+	// A copy that disappears is reported as missing
+	require.NoError(t, server2.Storage().Delete(crypto.HashKey(key)))
+	report, err = server1.Replicas(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, fs.ReplicaMissing, replicaStatuses(report)[server2.ID])
+	assert.Equal(t, 1, report.Healthy)
+}
+
+func replicaStatuses(report fs.ReplicaReport) map[string]fs.ReplicaStatus {
+	statuses := make(map[string]fs.ReplicaStatus, len(report.Replicas))
+	for _, r := range report.Replicas {
+		statuses[r.Node] = r.Status
+	}
+	return statuses
+}
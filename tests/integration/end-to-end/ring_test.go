@@ -0,0 +1,95 @@
+package end_to_end
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRingPlacement stores files on a three node cluster with two copies
+// per file, then adds a fourth node and checks that only the files it now
+// owns are copied to it.
+func TestRingPlacement(t *testing.T) {
+	servers := map[string]*fs.Server{}
+	start := func(addr string, bootstrap ...string) *fs.Server {
+		s := createTestServer(addr, bootstrap)
+		s.ReplicationFactor = 2
+		require.NoError(t, s.Start())
+		t.Cleanup(s.Stop)
+		servers[s.ID] = s
+		return s
+	}
+	server1 := start(":3021")
+	start(":3022", ":3021")
+	start(":3023", ":3021", ":3022")
+	require.Eventually(t, func() bool { return server1.Ring().Len() == 3 }, 5*time.Second, 50*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("ring_%d_%d.txt", time.Now().UnixNano(), i)
+		require.NoError(t, server1.Store(ctx, keys[i], bytes.NewReader([]byte("content of "+keys[i]))))
+	}
+
+	// Each file is on its owners, and on server1 which it was stored on
+	holders := func(key string) []string {
+		var ids []string
+		for id, s := range servers {
+			if s.Storage().Has(crypto.HashKey(key)) || s.Storage().Has(key) {
+				ids = append(ids, id)
+			}
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	expected := func(key string) []string {
+		ids := append(server1.Ring().Owners(crypto.HashKey(key), 2), server1.ID)
+		slices.Sort(ids)
+		return slices.Compact(ids)
+	}
+	for _, key := range keys {
+		assert.Equal(t, expected(key), holders(key), key)
+	}
+
+	server4 := start(":3024", ":3021", ":3022", ":3023")
+	require.Eventually(t, func() bool { return server1.Ring().Len() == 4 }, 5*time.Second, 50*time.Millisecond)
+	owned := 0
+	for _, key := range keys {
+		if server1.Ring().Owns(server4.ID, crypto.HashKey(key), 2) {
+			owned++
+		}
+	}
+	require.NotZero(t, owned, "the new node owns some of the files")
+	require.Less(t, owned, len(keys), "and not all of them")
+
+	// Files move once the ring has settled
+	require.Eventually(t, func() bool {
+		for _, key := range keys {
+			if !slices.Equal(expected(key), holders(key)) {
+				return false
+			}
+		}
+		return true
+	}, 15*time.Second, 100*time.Millisecond)
+
+	// The new node serves the files it owns from its own copy, and fetches
+	// the others from their owners
+	for _, key := range keys {
+		r, err := server4.Get(ctx, key)
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "content of "+key, string(data))
+	}
+}
@@ -0,0 +1,88 @@
+package end_to_end
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSwarmDownload stores a large file on three of four nodes and checks
+// that the fourth fetches its pieces from more than one of them.
+func TestSwarmDownload(t *testing.T) {
+	addrs := []string{":3061", ":3062", ":3063", ":3064"}
+	var servers []*fs.Server
+	for i, addr := range addrs {
+		s := createTestServer(addr, addrs[:i])
+		s.SwarmMinSize = 1 << 20
+		require.NoError(t, s.Start())
+		t.Cleanup(s.Stop)
+		servers = append(servers, s)
+	}
+	downloader := servers[3]
+	require.Eventually(t, func() bool {
+		for _, s := range servers {
+			if s.Ring().Len() != len(servers) || s.Metrics()["peers"] != float64(len(servers)-1) {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 50*time.Millisecond)
+
+	// Pick a key the downloader does not own, so it keeps no copy of its own
+	var key string
+	var owners []string
+	for i := 0; ; i++ {
+		key = fmt.Sprintf("swarm_%d_%d.bin", time.Now().UnixNano(), i)
+		owners = downloader.Ring().Owners(crypto.HashKey(key), fs.DefaultReplicationFactor)
+		if !slices.Contains(owners, downloader.ID) {
+			break
+		}
+	}
+	var writer *fs.Server
+	for _, s := range servers {
+		if s.ID == owners[0] {
+			writer = s
+		}
+	}
+
+	data := make([]byte, 5<<20+1234)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	require.NoError(t, writer.Store(ctx, key, bytes.NewReader(data)))
+	require.Eventually(t, func() bool {
+		for _, s := range servers[:3] {
+			if !s.Storage().Has(crypto.HashKey(key)) && !s.Storage().Has(key) {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 50*time.Millisecond)
+
+	r, err := downloader.Get(ctx, key)
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, got), "the downloaded file differs")
+	assert.Equal(t, float64(1), downloader.Metrics()["swarm_downloads_total"])
+
+	served := 0
+	for _, s := range servers[:3] {
+		if s.Metrics()["swarm_pieces_served_total"] > 0 {
+			served++
+		}
+	}
+	assert.GreaterOrEqual(t, served, 2, "pieces come from more than one holder")
+}
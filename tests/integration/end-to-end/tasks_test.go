@@ -0,0 +1,60 @@
+package end_to_end
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"testing"
+	"time"
+
+	fs "github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/tasks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRemoteTasks runs the handlers of one node from another, streaming
+// more than a flow control window each way
+func TestRemoteTasks(t *testing.T) {
+	start := func(s *fs.Server, allow map[string][]string) {
+		s.Tasks = tasks.New(tasks.Options{Allow: allow})
+		require.NoError(t, tasks.RegisterBuiltins(s.Tasks))
+		require.NoError(t, s.Start())
+		t.Cleanup(s.Stop)
+	}
+	server1 := createTestServer(":3131", nil)
+	start(server1, nil)
+	server2 := createTestServer(":3132", []string{":3131"})
+	start(server2, map[string][]string{"compress": {server1.ID}, "hash-check": {"*"}})
+
+	require.Eventually(t, func() bool {
+		return len(server1.Tasks.Nodes("compress")) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, []string{server2.ID}, server1.Tasks.Nodes("hash-check"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	data := make([]byte, 3*tasks.Window)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	var compressed bytes.Buffer
+	require.NoError(t, server1.Tasks.Invoke(ctx, server2.ID, "compress", nil, bytes.NewReader(data), &compressed))
+	zr, err := gzip.NewReader(&compressed)
+	require.NoError(t, err)
+	got, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, got), "decompressed output differs from the input")
+
+	sum := sha256.Sum256(data)
+	var digest bytes.Buffer
+	require.NoError(t, server1.Tasks.Invoke(ctx, server2.ID, "hash-check", nil, bytes.NewReader(data), &digest))
+	assert.Equal(t, hex.EncodeToString(sum[:]), digest.String())
+
+	// Node 1 lets no peer run its handlers
+	err = server2.Tasks.Invoke(ctx, server1.ID, "compress", nil, bytes.NewReader(data), io.Discard)
+	assert.ErrorIs(t, err, tasks.ErrDenied)
+}
@@ -2,16 +2,24 @@ package graphql_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/Skpow1234/Peervault/internal/api/graphql"
+	"github.com/Skpow1234/Peervault/internal/api/graphql/resolvers"
+	"github.com/Skpow1234/Peervault/internal/api/graphql/types"
 	"github.com/Skpow1234/Peervault/internal/app/fileserver"
 	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/lwm2m"
 	"github.com/Skpow1234/Peervault/internal/peer"
+	"github.com/Skpow1234/Peervault/internal/requestid"
 	"github.com/Skpow1234/Peervault/internal/storage"
+	"github.com/Skpow1234/Peervault/internal/telemetry"
 	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
 )
 
@@ -199,6 +207,9 @@ func TestGraphQLServerCORS(t *testing.T) {
 		if err != nil {
 			t.Fatalf("Failed to create OPTIONS request: %v", err)
 		}
+		req.Header.Set("Origin", "http://localhost:3000")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "Content-Type")
 
 		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
@@ -230,3 +241,121 @@ func TestGraphQLServerCORS(t *testing.T) {
 	// Clean up
 	server.Stop()
 }
+
+func TestGraphQLErrorModel(t *testing.T) {
+	graphqlServer := graphql.NewServer(nil, nil)
+	testServer := httptest.NewServer(requestid.Middleware(http.HandlerFunc(graphqlServer.GraphQLHandler)))
+	defer testServer.Close()
+
+	for body, message := range map[string]string{"{": "Invalid JSON", `{"query": ""}`: "Missing query"} {
+		req, err := http.NewRequest("POST", testServer.URL, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.Header.Set("X-Request-ID", "trace-12")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		var response graphql.GraphQLResponse
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", resp.StatusCode)
+		}
+		if len(response.Errors) != 1 {
+			t.Fatalf("Expected one error, got %d", len(response.Errors))
+		}
+		e := response.Errors[0]
+		if e.Message != message || e.Extensions["code"] != "invalid_argument" || e.Extensions["request_id"] != "trace-12" {
+			t.Errorf("Unexpected error %+v", e)
+		}
+	}
+}
+
+// sensorTransport answers every read of a device with a temperature and
+// keeps the notify function of the observation
+type sensorTransport struct {
+	notify func(lwm2m.Response)
+}
+
+func (s *sensorTransport) Send(ctx context.Context, addr string, req lwm2m.Request) (lwm2m.Response, error) {
+	return lwm2m.Response{Code: 0x45, ContentFormat: lwm2m.FormatText, Payload: []byte("21.5")}, nil
+}
+
+func (s *sensorTransport) Observe(ctx context.Context, addr string, req lwm2m.Request, notify func(lwm2m.Response)) (lwm2m.Response, func(), error) {
+	s.notify = notify
+	resp, _ := s.Send(ctx, addr, req)
+	return resp, func() {}, nil
+}
+
+func TestGraphQLTelemetry(t *testing.T) {
+	t.Chdir(t.TempDir())
+	devices := lwm2m.New(lwm2m.Options{})
+	transport := &sensorTransport{}
+	devices.Attach(transport)
+	node := fileserver.New(fileserver.Options{
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		LwM2M:             devices,
+		Telemetry:         telemetry.NewCollector(telemetry.Options{}),
+	})
+	resolver := resolvers.NewResolver(node)
+	ctx := context.Background()
+
+	// The values a device notifies for its observed paths are recorded
+	if _, err := devices.Register(ctx, lwm2m.RegisterRequest{Endpoint: "sensor-1", Links: "</3303/0>", Addr: "192.0.2.1:5683"}); err != nil {
+		t.Fatalf("Failed to register the device: %v", err)
+	}
+	if _, err := devices.Observe(ctx, "sensor-1", "/3303/0/5700"); err != nil {
+		t.Fatalf("Failed to observe the temperature: %v", err)
+	}
+	transport.notify(lwm2m.Response{Code: 0x45, ContentFormat: lwm2m.FormatText, Payload: []byte("23.5")})
+	now := time.Now()
+	for _, value := range []float64{40, 60} {
+		if err := node.Telemetry.Record(telemetry.Reading{Time: now, Device: "sensor-2", Metric: "/3303/0/5700", Value: value}); err != nil {
+			t.Fatalf("Failed to record a reading: %v", err)
+		}
+	}
+
+	interval, aggregate := "1h", types.TelemetryAggregateMax
+	series, err := resolver.Telemetry(ctx, nil, nil, nil, nil, &interval, &aggregate, []types.TelemetryDimension{types.TelemetryDimensionDevice})
+	if err != nil {
+		t.Fatalf("Failed to query telemetry: %v", err)
+	}
+	if len(series) != 2 || *series[0].Device != "sensor-1" || *series[1].Device != "sensor-2" || series[0].Metric != nil {
+		t.Fatalf("Expected a series per device, got %+v", series)
+	}
+	point := series[0].Points[0]
+	if point.Value != 23.5 || point.Count != 2 || point.Avg != 22.5 || point.Min != 21.5 || series[0].Aggregate != types.TelemetryAggregateMax {
+		t.Errorf("Expected the maximum of both temperatures, got %+v", point)
+	}
+
+	// Without grouping, the readings of every device are merged
+	series, err = resolver.Telemetry(ctx, nil, nil, nil, nil, nil, nil, nil)
+	if err != nil || len(series) != 1 {
+		t.Fatalf("Expected one series, got %+v (%v)", series, err)
+	}
+	var count int64
+	var sum float64
+	for _, p := range series[0].Points {
+		count += p.Count
+		sum += p.Sum
+		if p.Value != p.Avg {
+			t.Errorf("Expected the average as the value, got %+v", p)
+		}
+	}
+	if count != 4 || sum != 145 {
+		t.Errorf("Expected every reading, got %d summing to %v", count, sum)
+	}
+
+	interval = "90s"
+	if _, err := resolver.Telemetry(ctx, nil, nil, nil, nil, &interval, nil, nil); !errors.Is(err, telemetry.ErrInvalidQuery) {
+		t.Errorf("Expected an interval that is no multiple of a minute to be refused, got %v", err)
+	}
+}
@@ -1,14 +1,35 @@
 package grpc_test
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	googlegrpc "google.golang.org/grpc"
+	channelzpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
 
 	"github.com/Skpow1234/Peervault/internal/api/grpc"
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"github.com/Skpow1234/Peervault/internal/messaging"
+	"github.com/Skpow1234/Peervault/internal/streamlog"
+	"github.com/Skpow1234/Peervault/internal/tasks"
 )
 
 func TestGRPCServerCreation(t *testing.T) {
@@ -101,3 +122,320 @@ func TestGRPCServerStartStop(t *testing.T) {
 	err := server.Stop()
 	assert.NoError(t, err)
 }
+
+// serve starts a server on a free port and returns its address
+func serve(t *testing.T, config *grpc.Config) (*grpc.Server, string) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer(config, nil)
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(func() { _ = server.Stop() })
+	return server, lis.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *googlegrpc.ClientConn {
+	conn, err := googlegrpc.NewClient(addr, googlegrpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestGRPCStandardHealth(t *testing.T) {
+	server, addr := serve(t, grpc.DefaultConfig())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client := healthpb.NewHealthClient(dial(t, addr))
+
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+	resp, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: healthpb.Health_ServiceDesc.ServiceName})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	watch, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	update, err := watch.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, update.Status)
+	server.SetServingStatus("", false)
+	update, err = watch.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, update.Status)
+
+	// The JSON endpoints share the port
+	httpResp, err := http.Get("http://" + addr + "/health")
+	require.NoError(t, err)
+	defer func() { _ = httpResp.Body.Close() }()
+	assert.Equal(t, http.StatusOK, httpResp.StatusCode)
+}
+
+func TestGRPCOverTLS(t *testing.T) {
+	// Borrow the test certificate of httptest
+	certSource := httptest.NewTLSServer(http.NotFoundHandler())
+	cert := certSource.TLS.Certificates[0]
+	certSource.Close()
+
+	config := grpc.DefaultConfig()
+	config.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	_, addr := serve(t, config)
+	clientTLS := &tls.Config{InsecureSkipVerify: true}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := googlegrpc.NewClient(addr, googlegrpc.WithTransportCredentials(credentials.NewTLS(clientTLS)))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	// The JSON endpoints are served over HTTPS too
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+	httpResp, err := client.Get("https://" + addr + "/health")
+	require.NoError(t, err)
+	defer func() { _ = httpResp.Body.Close() }()
+	assert.Equal(t, http.StatusOK, httpResp.StatusCode)
+	assert.NotNil(t, httpResp.TLS)
+}
+
+func TestGRPCReflectionAndChannelz(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	listServices := func(conn *googlegrpc.ClientConn) ([]string, error) {
+		stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := stream.Send(&reflectionpb.ServerReflectionRequest{MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{}}); err != nil {
+			return nil, err
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, s := range resp.GetListServicesResponse().GetService() {
+			names = append(names, s.Name)
+		}
+		return names, nil
+	}
+
+	config := grpc.DefaultConfig()
+	config.EnableChannelz = true
+	_, addr := serve(t, config)
+	conn := dial(t, addr)
+	names, err := listServices(conn)
+	require.NoError(t, err)
+	assert.Contains(t, names, "grpc.health.v1.Health")
+	assert.Contains(t, names, "grpc.channelz.v1.Channelz")
+	servers, err := channelzpb.NewChannelzClient(conn).GetServers(ctx, &channelzpb.GetServersRequest{})
+	require.NoError(t, err)
+	assert.NotEmpty(t, servers.Server)
+
+	config = grpc.DefaultConfig()
+	config.EnableReflection = false
+	_, addr = serve(t, config)
+	conn = dial(t, addr)
+	_, err = listServices(conn)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+	_, err = channelzpb.NewChannelzClient(conn).GetServers(ctx, &channelzpb.GetServersRequest{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err), "channelz is off by default")
+}
+
+func TestGRPCDirectories(t *testing.T) {
+	_, addr := serve(t, grpc.DefaultConfig())
+	base := "http://" + addr + "/directories/"
+	do := func(method, url, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		return resp
+	}
+
+	require.Equal(t, http.StatusOK, do("PUT", base+"reports/2026", "").StatusCode)
+
+	var listing directory.Listing
+	resp := do("GET", base, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&listing))
+	require.Len(t, listing.Directories, 1)
+	assert.Equal(t, "reports/", listing.Directories[0].Path)
+
+	resp = do("POST", base+"move", `{"from":"reports","to":"archive/reports"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = do("GET", base+"archive/reports?recursive=true", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&listing))
+	require.Len(t, listing.Directories, 1)
+	assert.True(t, listing.Directories[0].Explicit)
+
+	assert.Equal(t, http.StatusNotFound, do("GET", base+"reports", "").StatusCode)
+	assert.Equal(t, http.StatusBadRequest, do("POST", base+"move", `{"from":"archive","to":"archive/inside"}`).StatusCode)
+}
+
+func TestGRPCLogStream(t *testing.T) {
+	_, addr := serve(t, grpc.DefaultConfig())
+	base := "http://" + addr + "/logs/sensors"
+
+	req, err := http.NewRequest("POST", base+"/records", strings.NewReader("{\"t\":20.5}\n{\"t\":21}\n"))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// A followed stream carries the stored records, then the new ones
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err = http.NewRequestWithContext(ctx, "GET", base+"/records?offset=1&follow=true", nil)
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	dec := json.NewDecoder(resp.Body)
+
+	var rec streamlog.Record
+	require.NoError(t, dec.Decode(&rec))
+	assert.Equal(t, uint64(1), rec.Offset)
+	assert.Equal(t, `{"t":21}`, string(rec.Data))
+
+	resp2, err := http.Post(base+"/records", "application/json", strings.NewReader(`{"t":22}`))
+	require.NoError(t, err)
+	_ = resp2.Body.Close()
+	require.NoError(t, dec.Decode(&rec))
+	assert.Equal(t, uint64(2), rec.Offset)
+	assert.Equal(t, `{"t":22}`, string(rec.Data))
+
+	var info streamlog.Info
+	resp3, err := http.Get(base)
+	require.NoError(t, err)
+	defer func() { _ = resp3.Body.Close() }()
+	require.NoError(t, json.NewDecoder(resp3.Body).Decode(&info))
+	assert.Equal(t, uint64(3), info.NextOffset)
+
+	resp4, err := http.Get("http://" + addr + "/logs/missing/records")
+	require.NoError(t, err)
+	_ = resp4.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp4.StatusCode)
+}
+
+func TestGRPCMessages(t *testing.T) {
+	mailbox := messaging.New(messaging.Options{})
+	mailbox.Attach("node-1", nil)
+	config := grpc.DefaultConfig()
+	config.Messaging = mailbox
+	_, addr := serve(t, config)
+	base := "http://" + addr
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream := func(url string) *json.Decoder {
+		t.Helper()
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return json.NewDecoder(resp.Body)
+	}
+	receipts := stream(base + "/receipts")
+	messages := stream(base + "/messages?channel=jobs")
+
+	resp, err := http.Post(base+"/messages", "application/json", strings.NewReader(`{"node":"node-1","channel":"jobs","payload":"am9iIDE=","ttl_seconds":60}`))
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var sent messaging.Delivery
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&sent))
+
+	var m messaging.Message
+	require.NoError(t, messages.Decode(&m))
+	assert.Equal(t, sent.ID, m.ID)
+	assert.Equal(t, "job 1", string(m.Payload))
+	for _, want := range []messaging.Status{messaging.Queued, messaging.Delivered} {
+		var r messaging.Receipt
+		require.NoError(t, receipts.Decode(&r))
+		assert.Equal(t, want, r.Status)
+	}
+
+	resp2, err := http.Get(base + "/messages/" + sent.ID)
+	require.NoError(t, err)
+	defer func() { _ = resp2.Body.Close() }()
+	var status messaging.Delivery
+	require.NoError(t, json.NewDecoder(resp2.Body).Decode(&status))
+	assert.Equal(t, messaging.Delivered, status.Status)
+
+	resp3, err := http.Get(base + "/messages/unknown")
+	require.NoError(t, err)
+	_ = resp3.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp3.StatusCode)
+	resp4, err := http.Post(base+"/messages", "application/json", strings.NewReader(`{"node":"node-1","channel":"bad channel"}`))
+	require.NoError(t, err)
+	_ = resp4.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp4.StatusCode)
+}
+
+func TestGRPCTasks(t *testing.T) {
+	host := tasks.New(tasks.Options{})
+	require.NoError(t, tasks.RegisterBuiltins(host))
+	require.NoError(t, host.Register("fail-late", tasks.HandlerFunc(func(ctx context.Context, args map[string]string, in io.Reader, out io.Writer) error {
+		_, _ = io.WriteString(out, "partial")
+		return errors.New("gave up")
+	})))
+	host.Attach("node-1", nil)
+	config := grpc.DefaultConfig()
+	config.Tasks = host
+	_, addr := serve(t, config)
+	base := "http://" + addr
+
+	resp, err := http.Get(base + "/tasks")
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	var list struct {
+		Handlers []string `json:"handlers"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	assert.Equal(t, []string{"compress", "decompress", "fail-late", "hash-check"}, list.Handlers)
+
+	// The request body is the input and the response body the output
+	data := strings.Repeat("peervault ", 10000)
+	resp2, err := http.Post(base+"/tasks/node-1/compress?level=9", "application/octet-stream", strings.NewReader(data))
+	require.NoError(t, err)
+	defer func() { _ = resp2.Body.Close() }()
+	require.Equal(t, http.StatusOK, resp2.StatusCode)
+	zr, err := gzip.NewReader(resp2.Body)
+	require.NoError(t, err)
+	got, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, data, string(got))
+
+	for path, want := range map[string]int{
+		"/tasks/node-1/hash-check?sha256=00": http.StatusUnprocessableEntity,
+		"/tasks/node-1/missing":              http.StatusNotFound,
+		"/tasks/node-2/hash-check":           http.StatusServiceUnavailable,
+	} {
+		resp, err := http.Post(base+path, "application/octet-stream", strings.NewReader("data"))
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, want, resp.StatusCode, path)
+	}
+
+	// Errors after the output started end it with a trailer
+	resp3, err := http.Post(base+"/tasks/node-1/fail-late", "application/octet-stream", nil)
+	require.NoError(t, err)
+	defer func() { _ = resp3.Body.Close() }()
+	require.Equal(t, http.StatusOK, resp3.StatusCode)
+	body, err := io.ReadAll(resp3.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "partial", string(body))
+	assert.Contains(t, resp3.Trailer.Get(grpc.TaskErrorTrailer), "gave up")
+}
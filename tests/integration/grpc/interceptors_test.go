@@ -0,0 +1,113 @@
+package grpc_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	googlegrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/Skpow1234/Peervault/internal/api/apierror"
+	"github.com/Skpow1234/Peervault/internal/api/grpc"
+	"github.com/Skpow1234/Peervault/internal/api/grpc/interceptors"
+)
+
+func TestGRPCInterceptorAuth(t *testing.T) {
+	auth := interceptors.DefaultAuthConfig()
+	auth.SecretKey = "jwt-secret"
+	auth.Tokens = map[string]string{"static-token": "alice"}
+	config := grpc.DefaultConfig()
+	config.Interceptors = &interceptors.ChainConfig{Recovery: true, Logging: interceptors.DefaultLoggingConfig(), Auth: auth}
+	_, addr := serve(t, config)
+	conn := dial(t, addr)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	reflect := func(ctx context.Context) error {
+		stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&reflectionpb.ServerReflectionRequest{MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{}}); err != nil {
+			return err
+		}
+		_, err = stream.Recv()
+		return err
+	}
+	withToken := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+
+	// Health checks need no token
+	_, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	assert.Equal(t, codes.Unauthenticated, status.Code(reflect(ctx)))
+	assert.Equal(t, codes.Unauthenticated, status.Code(reflect(withToken("wrong"))))
+	assert.NoError(t, reflect(withToken("static-token")))
+
+	jwt, err := interceptors.NewAuthInterceptor(auth, nil).GenerateToken("bob", []string{"admin"})
+	require.NoError(t, err)
+	assert.NoError(t, reflect(withToken(jwt)))
+	other := *auth
+	other.SecretKey = "another-secret"
+	forged, err := interceptors.NewAuthInterceptor(&other, nil).GenerateToken("bob", []string{"admin"})
+	require.NoError(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(reflect(withToken(forged))))
+}
+
+func TestGRPCInterceptorRequestIDAndRateLimit(t *testing.T) {
+	config := grpc.DefaultConfig()
+	config.Interceptors = interceptors.DefaultChainConfig()
+	config.Interceptors.RateLimit = &interceptors.RateLimitConfig{RequestsPerSecond: 1, Burst: 2}
+	_, addr := serve(t, config)
+	client := healthpb.NewHealthClient(dial(t, addr))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The server generates request IDs, or keeps the client's
+	var header metadata.MD
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, googlegrpc.Header(&header))
+	require.NoError(t, err)
+	assert.Len(t, header.Get(interceptors.RequestIDHeader), 1)
+	assert.NotEmpty(t, header.Get(interceptors.RequestIDHeader)[0])
+	_, err = client.Check(metadata.AppendToOutgoingContext(ctx, interceptors.RequestIDHeader, "trace-42"), &healthpb.HealthCheckRequest{}, googlegrpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"trace-42"}, header.Get(interceptors.RequestIDHeader))
+
+	// The burst is spent; the error is in the error model and names the call
+	_, err = client.Check(metadata.AppendToOutgoingContext(ctx, interceptors.RequestIDHeader, "trace-43"), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	apiErr := apierror.FromGRPC(err)
+	assert.Equal(t, apierror.CodeRateLimited, apiErr.Code)
+	assert.Equal(t, "trace-43", apiErr.RequestID)
+}
+
+func TestGRPCInterceptorRecovery(t *testing.T) {
+	recovery := interceptors.NewRecoveryInterceptor(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	_, err := recovery.UnaryRecoveryInterceptor()(context.Background(), nil, &googlegrpc.UnaryServerInfo{FullMethod: "/test.Service/Unary"},
+		func(context.Context, interface{}) (interface{}, error) { panic("boom") })
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	err = recovery.StreamRecoveryInterceptor()(nil, &fakeStream{ctx: context.Background()}, &googlegrpc.StreamServerInfo{FullMethod: "/test.Service/Stream"},
+		func(interface{}, googlegrpc.ServerStream) error { panic("boom") })
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+// fakeStream is a server stream with nothing but a context
+type fakeStream struct {
+	googlegrpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeStream) Context() context.Context { return s.ctx }
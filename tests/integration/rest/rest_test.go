@@ -2,17 +2,70 @@ package rest_test
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
 	"io"
 	"log/slog"
+	"maps"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/Skpow1234/Peervault/internal/alerting"
+	"github.com/Skpow1234/Peervault/internal/analytics"
+	"github.com/Skpow1234/Peervault/internal/api/mqtt/topicacl"
 	"github.com/Skpow1234/Peervault/internal/api/rest"
+	"github.com/Skpow1234/Peervault/internal/api/rest/endpoints"
 	"github.com/Skpow1234/Peervault/internal/api/rest/types/requests"
 	"github.com/Skpow1234/Peervault/internal/api/rest/types/responses"
+	"github.com/Skpow1234/Peervault/internal/app/fileserver"
+	"github.com/Skpow1234/Peervault/internal/backup"
+	"github.com/Skpow1234/Peervault/internal/consensus"
+	"github.com/Skpow1234/Peervault/internal/crypto"
+	"github.com/Skpow1234/Peervault/internal/devices"
+	"github.com/Skpow1234/Peervault/internal/directory"
+	"github.com/Skpow1234/Peervault/internal/export"
+	"github.com/Skpow1234/Peervault/internal/firmware"
+	"github.com/Skpow1234/Peervault/internal/georeplication"
+	"github.com/Skpow1234/Peervault/internal/grafana"
+	"github.com/Skpow1234/Peervault/internal/hooks"
+	"github.com/Skpow1234/Peervault/internal/jsondoc"
+	"github.com/Skpow1234/Peervault/internal/lifecycle"
+	"github.com/Skpow1234/Peervault/internal/lwm2m"
+	"github.com/Skpow1234/Peervault/internal/media"
+	"github.com/Skpow1234/Peervault/internal/metadata"
+	"github.com/Skpow1234/Peervault/internal/notify"
+	"github.com/Skpow1234/Peervault/internal/policy"
+	"github.com/Skpow1234/Peervault/internal/queue"
+	"github.com/Skpow1234/Peervault/internal/scan"
+	"github.com/Skpow1234/Peervault/internal/scheduler"
+	"github.com/Skpow1234/Peervault/internal/snapshot"
+	"github.com/Skpow1234/Peervault/internal/storage"
+	"github.com/Skpow1234/Peervault/internal/streamlog"
+	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
+	"github.com/Skpow1234/Peervault/internal/transport/p2p/acl"
+	"github.com/Skpow1234/Peervault/pkg/plugin"
 )
 
 func setupTestServer() *rest.Server {
@@ -22,6 +75,24 @@ func setupTestServer() *rest.Server {
 	return rest.NewServer(config, logger)
 }
 
+// uploadTestFile uploads content to the server under key
+func uploadTestFile(t *testing.T, restServer *rest.Server, key, content string) {
+	t.Helper()
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, _ := writer.CreateFormFile("file", key)
+	_, _ = part.Write([]byte(content))
+	_ = writer.WriteField("path", key)
+	_ = writer.Close()
+	req := httptest.NewRequest("POST", "/api/v1/files", &form)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	restServer.FileEndpoints.HandleUploadFile(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201 uploading %s, got %d: %s", key, w.Code, w.Body.String())
+	}
+}
+
 func TestRESTAPIHealth(t *testing.T) {
 	restServer := setupTestServer()
 
@@ -112,6 +183,7 @@ func TestRESTAPIRoot(t *testing.T) {
 
 func TestRESTAPIFiles(t *testing.T) {
 	restServer := setupTestServer()
+	uploadTestFile(t, restServer, "file1", "example")
 
 	// Test list files
 	req := httptest.NewRequest("GET", "/api/v1/files", nil)
@@ -152,166 +224,3480 @@ func TestRESTAPIFiles(t *testing.T) {
 	}
 }
 
-func TestRESTAPIPeers(t *testing.T) {
+func TestRESTAPIFileMetadataPatch(t *testing.T) {
 	restServer := setupTestServer()
-
-	// Test list peers
-	req := httptest.NewRequest("GET", "/api/v1/peers", nil)
+	uploadTestFile(t, restServer, "file1", "example")
+
+	body, _ := json.Marshal(requests.FileMetadataPatchRequest{
+		Set:     map[string]string{"project": "apollo"},
+		AddTags: []string{"Report"},
+	})
+	req := httptest.NewRequest("PATCH", "/api/v1/files/file1/metadata", bytes.NewBuffer(body))
+	req.SetPathValue("key", "file1")
 	w := httptest.NewRecorder()
 
-	restServer.PeerEndpoints.HandleListPeers(w, req)
+	restServer.FileEndpoints.HandlePatchFileMetadata(w, req)
 
 	if w.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", w.Code)
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
 	}
 
-	var response responses.PeerListResponse
-	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+	var fileResponse responses.FileResponse
+	if err := json.NewDecoder(w.Body).Decode(&fileResponse); err != nil {
 		t.Fatalf("Failed to decode response: %v", err)
 	}
+	if fileResponse.Metadata["project"] != "apollo" {
+		t.Errorf("Expected metadata project=apollo, got %v", fileResponse.Metadata)
+	}
+	if len(fileResponse.Tags) != 1 || fileResponse.Tags[0] != "report" {
+		t.Errorf("Expected tags [report], got %v", fileResponse.Tags)
+	}
 
-	if len(response.Peers) == 0 {
-		t.Error("Expected at least one peer in response")
+	// Search by the new tag and metadata
+	req = httptest.NewRequest("GET", "/api/v1/files?tag=report&meta.project=apollo", nil)
+	w = httptest.NewRecorder()
+	restServer.FileEndpoints.HandleListFiles(w, req)
+
+	var listResponse responses.FileListResponse
+	if err := json.NewDecoder(w.Body).Decode(&listResponse); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if listResponse.Total != 1 {
+		t.Errorf("Expected 1 matching file, got %d", listResponse.Total)
 	}
 
-	// Test get peer
-	req = httptest.NewRequest("GET", "/api/v1/peers/get?id=peer1", nil)
+	// Unknown files are reported as not found
+	req = httptest.NewRequest("PATCH", "/api/v1/files/missing/metadata", bytes.NewBufferString("{}"))
+	req.SetPathValue("key", "missing")
 	w = httptest.NewRecorder()
+	restServer.FileEndpoints.HandlePatchFileMetadata(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
 
-	restServer.PeerEndpoints.HandleGetPeer(w, req)
+func TestRESTAPISearch(t *testing.T) {
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.SearchEnabled = true
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	restServer := rest.NewServer(config, logger)
+
+	if setupTestServer().SearchEndpoints != nil {
+		t.Error("Expected search to be disabled by default")
+	}
+
+	// Upload a document to index
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, _ := writer.CreateFormFile("file", "notes.md")
+	_, _ = part.Write([]byte("# Launch plan\n\nThe **rocket** launches on Friday."))
+	_ = writer.Close()
+
+	req := httptest.NewRequest("POST", "/api/v1/files", &form)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	restServer.FileEndpoints.HandleUploadFile(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
 
+	req = httptest.NewRequest("GET", "/api/v1/search?q=rocket", nil)
+	w = httptest.NewRecorder()
+	restServer.SearchEndpoints.HandleSearch(w, req)
 	if w.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", w.Code)
+		t.Fatalf("Expected status 200, got %d", w.Code)
 	}
 
-	var peerResponse responses.PeerResponse
-	if err := json.NewDecoder(w.Body).Decode(&peerResponse); err != nil {
+	var searchResponse responses.SearchResponse
+	if err := json.NewDecoder(w.Body).Decode(&searchResponse); err != nil {
 		t.Fatalf("Failed to decode response: %v", err)
 	}
+	if searchResponse.Total != 1 || searchResponse.Results[0].Name != "notes.md" {
+		t.Fatalf("Expected notes.md to match, got %+v", searchResponse)
+	}
+	if len(searchResponse.Results[0].Highlights) == 0 || !strings.Contains(searchResponse.Results[0].Highlights[0], "<mark>rocket</mark>") {
+		t.Errorf("Expected highlighted match, got %v", searchResponse.Results[0].Highlights)
+	}
 
-	if peerResponse.ID != "peer1" {
-		t.Errorf("Expected ID 'peer1', got '%s'", peerResponse.ID)
+	// A query is required
+	req = httptest.NewRequest("GET", "/api/v1/search", nil)
+	w = httptest.NewRecorder()
+	restServer.SearchEndpoints.HandleSearch(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/search/rebuild", nil)
+	w = httptest.NewRecorder()
+	restServer.SearchEndpoints.HandleRebuildIndex(w, req)
+	if w.Code != http.StatusAccepted && w.Code != http.StatusConflict {
+		t.Errorf("Expected status 202, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/search/status", nil)
+	w = httptest.NewRecorder()
+	restServer.SearchEndpoints.HandleSearchStatus(w, req)
+	var status responses.SearchStatusResponse
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if status.Documents != 1 {
+		t.Errorf("Expected 1 indexed document, got %d", status.Documents)
 	}
 }
 
-func TestRESTAPIAddPeer(t *testing.T) {
+func TestRESTAPIShareLinks(t *testing.T) {
 	restServer := setupTestServer()
 
-	request := requests.PeerAddRequest{
-		Address: "192.168.1.100",
-		Port:    8080,
-		Metadata: map[string]string{
-			"region": "us-east",
-		},
-	}
+	// Upload a file to share
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, _ := writer.CreateFormFile("file", "q3.txt")
+	_, _ = part.Write([]byte("quarterly numbers"))
+	_ = writer.Close()
 
-	body, _ := json.Marshal(request)
-	req := httptest.NewRequest("POST", "/api/v1/peers", bytes.NewBuffer(body))
-	req.Header.Set("Content-Type", "application/json")
+	req := httptest.NewRequest("POST", "/api/v1/files", &form)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
 	w := httptest.NewRecorder()
+	restServer.FileEndpoints.HandleUploadFile(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var file responses.FileResponse
+	if err := json.NewDecoder(w.Body).Decode(&file); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
 
-	restServer.PeerEndpoints.HandleAddPeer(w, req)
-
+	body, _ := json.Marshal(requests.ShareLinkCreateRequest{Key: file.Key, ExpiresIn: "1h", MaxDownloads: 1, Password: "secret"})
+	req = httptest.NewRequest("POST", "/api/v1/shares", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	restServer.ShareEndpoints.HandleCreateLink(w, req)
 	if w.Code != http.StatusCreated {
-		t.Errorf("Expected status 201, got %d", w.Code)
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var link responses.ShareLinkResponse
+	if err := json.NewDecoder(w.Body).Decode(&link); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !link.PasswordProtected || !link.Active || link.MaxDownloads != 1 {
+		t.Errorf("Unexpected link: %+v", link)
 	}
 
-	var response responses.PeerResponse
-	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+	open := func(rawURL, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", rawURL, nil)
+		req.SetPathValue("id", link.ID)
+		if password != "" {
+			req.Header.Set("X-Share-Password", password)
+		}
+		w := httptest.NewRecorder()
+		restServer.ShareEndpoints.HandleOpenLink(w, req)
+		return w
+	}
+
+	if w := open(link.URL, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without password, got %d", w.Code)
+	}
+	if w := open(strings.Replace(link.URL, "sig=", "sig=x", 1), "secret"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a tampered signature, got %d", w.Code)
+	}
+	w = open(link.URL, "secret")
+	if w.Code != http.StatusOK || w.Body.String() != "quarterly numbers" {
+		t.Fatalf("Expected file content, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := open(link.URL, "secret"); w.Code != http.StatusGone {
+		t.Errorf("Expected status 410 after the download limit, got %d", w.Code)
+	}
+
+	// Revoke and list
+	req = httptest.NewRequest("DELETE", "/api/v1/shares/"+link.ID, nil)
+	req.SetPathValue("id", link.ID)
+	w = httptest.NewRecorder()
+	restServer.ShareEndpoints.HandleRevokeLink(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/shares?key="+file.Key, nil)
+	w = httptest.NewRecorder()
+	restServer.ShareEndpoints.HandleListLinks(w, req)
+	var list responses.ShareLinkListResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
 		t.Fatalf("Failed to decode response: %v", err)
 	}
+	if list.Total != 1 || !list.Links[0].Revoked || list.Links[0].Downloads != 1 {
+		t.Errorf("Unexpected links: %+v", list)
+	}
 
-	if response.Address != "192.168.1.100" {
-		t.Errorf("Expected address '192.168.1.100', got '%s'", response.Address)
+	// Links can only be created for existing files
+	body, _ = json.Marshal(requests.ShareLinkCreateRequest{Key: "missing"})
+	req = httptest.NewRequest("POST", "/api/v1/shares", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	restServer.ShareEndpoints.HandleCreateLink(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
 	}
 }
 
-func TestRESTAPIWebhook(t *testing.T) {
-	restServer := setupTestServer()
-
-	request := requests.WebhookRequest{
-		Event:     "file.uploaded",
-		Timestamp: time.Now().Unix(),
-		Data: map[string]interface{}{
-			"file_id": "test123",
-			"size":    1024,
-		},
-		Source: "test-client",
+func TestRESTAPIGateway(t *testing.T) {
+	if setupTestServer().Gateway != nil {
+		t.Error("Expected the gateway to be disabled by default")
 	}
 
-	body, _ := json.Marshal(request)
-	req := httptest.NewRequest("POST", "/webhook", bytes.NewBuffer(body))
-	req.Header.Set("Content-Type", "application/json")
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.GatewayConfig.Enabled = true
+	config.GatewayConfig.Prefixes = []string{"file_"}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	restServer := rest.NewServer(config, logger)
+	defer func() { _ = restServer.Stop(context.Background()) }()
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, _ := writer.CreateFormFile("file", "readme.txt")
+	_, _ = part.Write([]byte("public content"))
+	_ = writer.Close()
+
+	req := httptest.NewRequest("POST", "/api/v1/files", &form)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
 	w := httptest.NewRecorder()
+	restServer.FileEndpoints.HandleUploadFile(w, req)
+	var file responses.FileResponse
+	if err := json.NewDecoder(w.Body).Decode(&file); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
 
-	restServer.SystemEndpoints.HandleWebhook(w, req)
+	req = httptest.NewRequest("GET", "/public/"+file.Key, nil)
+	req.SetPathValue("key", file.Key)
+	w = httptest.NewRecorder()
+	restServer.Gateway.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "public content" {
+		t.Fatalf("Expected public content, got %d: %s", w.Code, w.Body.String())
+	}
 
-	if w.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", w.Code)
+	// Files outside the whitelisted prefixes are not served
+	uploadTestFile(t, restServer, "file1", "private content")
+	req = httptest.NewRequest("GET", "/public/file1", nil)
+	req.SetPathValue("key", "file1")
+	w = httptest.NewRecorder()
+	restServer.Gateway.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
 	}
 }
 
-func TestRESTAPIDocs(t *testing.T) {
+func TestRESTAPIRetentionLocks(t *testing.T) {
 	restServer := setupTestServer()
+	uploadTestFile(t, restServer, "file1", "example")
+
+	lockRequest := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/v1/files/file1/lock", strings.NewReader(body))
+		req.SetPathValue("key", "file1")
+		w := httptest.NewRecorder()
+		restServer.RetentionEndpoints.HandleUpdateLock(w, req)
+		return w
+	}
+	deleteFile := func() int {
+		req := httptest.NewRequest("DELETE", "/api/v1/files?key=file1", nil)
+		w := httptest.NewRecorder()
+		restServer.FileEndpoints.HandleDeleteFile(w, req)
+		return w.Code
+	}
 
-	req := httptest.NewRequest("GET", "/docs", nil)
-	w := httptest.NewRecorder()
+	w := lockRequest(`{"retain_until":"30d","legal_hold":true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var lock responses.FileLockResponse
+	if err := json.NewDecoder(w.Body).Decode(&lock); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !lock.Locked || !lock.LegalHold || lock.RetainUntil == nil {
+		t.Fatalf("Expected file to be locked, got %+v", lock)
+	}
 
-	restServer.SystemEndpoints.HandleDocs(w, req)
+	if code := deleteFile(); code != http.StatusLocked {
+		t.Errorf("Expected delete of a locked file to return 423, got %d", code)
+	}
 
-	if w.Code != http.StatusOK {
+	// Retention cannot be shortened, and releasing the hold keeps it
+	if w := lockRequest(`{"retain_until":"1d"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", w.Code)
+	}
+	if w := lockRequest(`{"legal_hold":false}`); w.Code != http.StatusOK {
 		t.Errorf("Expected status 200, got %d", w.Code)
 	}
+	if code := deleteFile(); code != http.StatusLocked {
+		t.Errorf("Expected retention to keep blocking deletes, got %d", code)
+	}
 
-	contentType := w.Header().Get("Content-Type")
-	if contentType != "text/html" {
-		t.Errorf("Expected content type 'text/html', got '%s'", contentType)
+	req := httptest.NewRequest("GET", "/api/v1/locks", nil)
+	w = httptest.NewRecorder()
+	restServer.RetentionEndpoints.HandleListLocks(w, req)
+	var list responses.FileLockListResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if list.Total != 1 || list.Locks[0].Key != "file1" || list.Locks[0].LegalHold {
+		t.Errorf("Unexpected locks: %+v", list)
+	}
+
+	// Unknown files cannot be locked
+	req = httptest.NewRequest("PUT", "/api/v1/files/missing/lock", strings.NewReader(`{"legal_hold":true}`))
+	req.SetPathValue("key", "missing")
+	w = httptest.NewRecorder()
+	restServer.RetentionEndpoints.HandleUpdateLock(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
 	}
 }
 
-func TestRESTAPISwaggerJSON(t *testing.T) {
-	restServer := setupTestServer()
+func TestRESTAPILeases(t *testing.T) {
+	if setupTestServer().LeaseEndpoints != nil {
+		t.Fatal("Expected the lease API to be disabled without a Raft group")
+	}
+
+	// A single-member group elects itself
+	state := consensus.NewClusterState()
+	node, err := consensus.NewNode(consensus.Options{
+		ID:              "solo",
+		Peers:           []consensus.Peer{{ID: "solo"}},
+		AuthToken:       "raft-secret",
+		ElectionTimeout: 50 * time.Millisecond,
+	}, state)
+	if err != nil {
+		t.Fatalf("Failed to create raft node: %v", err)
+	}
+	node.Start()
+	defer node.Stop()
+	group := httptest.NewServer(consensus.Handler(node, state))
+	defer group.Close()
+
+	cluster, err := consensus.NewClient([]string{group.URL}, "raft-secret", nil)
+	if err != nil {
+		t.Fatalf("Failed to create raft client: %v", err)
+	}
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.Cluster = cluster
+	restServer := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	acquire := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/v1/leases/compactor", strings.NewReader(body))
+		req.SetPathValue("name", "compactor")
+		w := httptest.NewRecorder()
+		restServer.LeaseEndpoints.HandleAcquireLease(w, req)
+		return w
+	}
+	release := func(holder string) int {
+		req := httptest.NewRequest("DELETE", "/api/v1/leases/compactor?holder="+holder, nil)
+		req.SetPathValue("name", "compactor")
+		w := httptest.NewRecorder()
+		restServer.LeaseEndpoints.HandleReleaseLease(w, req)
+		return w.Code
+	}
+
+	// The group may still be electing itself
+	w := acquire(`{"holder":"worker-1","ttl_seconds":30}`)
+	for deadline := time.Now().Add(5 * time.Second); w.Code == http.StatusServiceUnavailable && time.Now().Before(deadline); {
+		time.Sleep(20 * time.Millisecond)
+		w = acquire(`{"holder":"worker-1","ttl_seconds":30}`)
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var lease consensus.Lease
+	if err := json.NewDecoder(w.Body).Decode(&lease); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if lease.Holder != "worker-1" || lease.Token == 0 {
+		t.Fatalf("Unexpected lease %+v", lease)
+	}
+
+	if w := acquire(`{"holder":"worker-2","ttl_seconds":30}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a held lease, got %d", w.Code)
+	}
+	if w := acquire(`{"holder":"worker-2"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a ttl, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/leases", nil)
+	w = httptest.NewRecorder()
+	restServer.LeaseEndpoints.HandleListLeases(w, req)
+	var list responses.LeaseListResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if list.Total != 1 || list.Leases[0].Token != lease.Token {
+		t.Errorf("Unexpected leases: %+v", list)
+	}
+
+	if code := release("worker-2"); code != http.StatusConflict {
+		t.Errorf("Expected status 409 releasing someone else's lease, got %d", code)
+	}
+	if code := release("worker-1"); code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/leases/compactor", nil)
+	req.SetPathValue("name", "compactor")
+	w = httptest.NewRecorder()
+	restServer.LeaseEndpoints.HandleGetLease(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 after release, got %d", w.Code)
+	}
+}
+
+func TestRESTAPIConflicts(t *testing.T) {
+	if setupTestServer().ConflictEndpoints != nil {
+		t.Fatal("Expected the conflict API to be disabled without a node")
+	}
+
+	// Storage roots are taken relative to the working directory
+	t.Chdir(t.TempDir())
+	node := fileserver.New(fileserver.Options{
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+	})
+	if err := node.Store(context.Background(), "notes.txt", strings.NewReader("only version")); err != nil {
+		t.Fatalf("Failed to store file: %v", err)
+	}
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.FileServer = node
+	restServer := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	endpoints := restServer.ConflictEndpoints
 
-	req := httptest.NewRequest("GET", "/swagger.json", nil)
 	w := httptest.NewRecorder()
+	endpoints.HandleListConflicts(w, httptest.NewRequest("GET", "/api/v1/conflicts", nil))
+	var list responses.ConflictListResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode conflicts: %v", err)
+	}
+	if list.Total != 0 || list.Conflicts == nil {
+		t.Errorf("Expected an empty conflict list, got %+v", list)
+	}
 
-	restServer.SystemEndpoints.HandleSwaggerJSON(w, req)
+	versionsOf := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/files/"+key+"/versions", nil)
+		req.SetPathValue("key", key)
+		w := httptest.NewRecorder()
+		endpoints.HandleGetVersions(w, req)
+		return w
+	}
+	w = versionsOf("notes.txt")
+	var versions fileserver.FileVersions
+	if err := json.NewDecoder(w.Body).Decode(&versions); err != nil {
+		t.Fatalf("Failed to decode versions: %v", err)
+	}
+	if len(versions.Versions) != 1 || !versions.Versions[0].Current || versions.Versions[0].Vector[node.ID] != 1 {
+		t.Fatalf("Expected one current version written by the node, got %+v", versions)
+	}
+	if w := versionsOf("missing.txt"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a file the node does not hold, got %d", w.Code)
+	}
 
-	if w.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", w.Code)
+	id := versions.Versions[0].ID
+	req := httptest.NewRequest("GET", "/api/v1/files/notes.txt/versions/"+id+"/content", nil)
+	req.SetPathValue("key", versions.HashedKey)
+	req.SetPathValue("id", id)
+	w = httptest.NewRecorder()
+	endpoints.HandleGetVersionContent(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "only version" {
+		t.Errorf("Expected the version content by hashed key, got %d %q", w.Code, w.Body.String())
 	}
 
-	contentType := w.Header().Get("Content-Type")
-	if contentType != "application/json" {
-		t.Errorf("Expected content type 'application/json', got '%s'", contentType)
+	pick := func(id string) int {
+		req := httptest.NewRequest("POST", "/api/v1/files/notes.txt/versions/"+id+"/pick", nil)
+		req.SetPathValue("key", "notes.txt")
+		req.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		endpoints.HandlePickVersion(w, req)
+		return w.Code
+	}
+	if code := pick(id); code != http.StatusConflict {
+		t.Errorf("Expected 409 resolving a file without conflicts, got %d", code)
 	}
+	req = httptest.NewRequest("POST", "/api/v1/files/notes.txt/versions/merge", strings.NewReader("merged"))
+	req.SetPathValue("key", "notes.txt")
+	w = httptest.NewRecorder()
+	endpoints.HandleMergeVersions(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 merging a file without conflicts, got %d", w.Code)
+	}
+}
 
-	var swaggerSpec map[string]interface{}
-	if err := json.NewDecoder(w.Body).Decode(&swaggerSpec); err != nil {
-		t.Fatalf("Failed to decode swagger spec: %v", err)
+func TestRESTAPIDocuments(t *testing.T) {
+	if setupTestServer().DocumentEndpoints != nil {
+		t.Fatal("Expected the document API to be disabled without a node")
+	}
+
+	t.Chdir(t.TempDir())
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.FileServer = fileserver.New(fileserver.Options{
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+	})
+	endpoints := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil))).DocumentEndpoints
+
+	update := func(name, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/api/v1/documents/"+name, strings.NewReader(body))
+		req.SetPathValue("name", name)
+		w := httptest.NewRecorder()
+		endpoints.HandleUpdateDocument(w, req)
+		return w
+	}
+	get := func(name string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/documents/"+name, nil)
+		req.SetPathValue("name", name)
+		w := httptest.NewRecorder()
+		endpoints.HandleGetDocument(w, req)
+		return w
+	}
+
+	if w := get("project"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing document, got %d", w.Code)
+	}
+	if w := update("project", `{"set":`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid body, got %d", w.Code)
+	}
+
+	w := update("project", `{"set":{"owner":"ana","limits":{"files":10}},"add":{"labels":["urgent","review"]}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w = update("project", `{"delete":["limits"],"remove":{"labels":["review"]}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var view struct {
+		Name   string                     `json:"name"`
+		Fields map[string]json.RawMessage `json:"fields"`
+		Sets   map[string][]string        `json:"sets"`
+	}
+	if err := json.NewDecoder(get("project").Body).Decode(&view); err != nil {
+		t.Fatalf("Failed to decode document: %v", err)
+	}
+	if view.Name != "project" || len(view.Fields) != 1 || string(view.Fields["owner"]) != `"ana"` {
+		t.Errorf("Expected only the owner field, got %+v", view.Fields)
+	}
+	if labels := view.Sets["labels"]; len(labels) != 1 || labels[0] != "urgent" {
+		t.Errorf("Expected the urgent label, got %v", labels)
 	}
 
-	if swaggerSpec["openapi"] != "3.0.3" {
-		t.Errorf("Expected OpenAPI version '3.0.3', got '%v'", swaggerSpec["openapi"])
+	w = httptest.NewRecorder()
+	endpoints.HandleListDocuments(w, httptest.NewRequest("GET", "/api/v1/documents", nil))
+	var list responses.DocumentListResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode documents: %v", err)
+	}
+	if list.Total != 1 || list.Documents[0] != "project" {
+		t.Errorf("Expected one document, got %+v", list)
 	}
 }
 
-func TestRESTAPICORS(t *testing.T) {
-	restServer := setupTestServer()
+func TestRESTAPIAnalytics(t *testing.T) {
+	t.Chdir(t.TempDir())
+	node := fileserver.New(fileserver.Options{
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+	})
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.FileServer = node
+	if rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil))).AnalyticsEndpoints != nil {
+		t.Fatal("Expected the analytics API to be disabled on a node without a collector")
+	}
+
+	node.Analytics = analytics.NewCollector(analytics.Options{})
+	endpoints := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil))).AnalyticsEndpoints
+	ctx := context.Background()
+	for _, key := range []string{"hot.txt", "cold.txt"} {
+		if err := node.Store(ctx, key, strings.NewReader("content")); err != nil {
+			t.Fatalf("Failed to store %s: %v", key, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := node.Get(ctx, "hot.txt"); err != nil {
+			t.Fatalf("Failed to read file: %v", err)
+		}
+	}
+	if err := node.Delete(ctx, "cold.txt"); err != nil {
+		t.Fatalf("Failed to delete file: %v", err)
+	}
 
-	req := httptest.NewRequest("OPTIONS", "/health", nil)
-	req.Header.Set("Origin", "http://localhost:3000")
 	w := httptest.NewRecorder()
+	endpoints.HandleGetRollups(w, httptest.NewRequest("GET", "/api/v1/analytics/access?value=hot.txt", nil))
+	var rollups responses.AccessRollupsResponse
+	if err := json.NewDecoder(w.Body).Decode(&rollups); err != nil {
+		t.Fatalf("Failed to decode rollups: %v", err)
+	}
+	if rollups.Dimension != analytics.ByKey || rollups.BucketSeconds != 3600 || len(rollups.Rollups) != 1 {
+		t.Fatalf("Expected one hourly rollup of hot.txt, got %+v", rollups)
+	}
+	if got := rollups.Rollups[0].Counters; got != (analytics.Counters{Reads: 2, Writes: 1, BytesRead: 14, BytesWritten: 7}) {
+		t.Errorf("Unexpected counters %+v", got)
+	}
 
-	restServer.CORSMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})).ServeHTTP(w, req)
+	w = httptest.NewRecorder()
+	endpoints.HandleGetTop(w, httptest.NewRequest("GET", "/api/v1/analytics/access/top?limit=1&from="+time.Now().Add(-time.Hour).UTC().Format(time.RFC3339), nil))
+	var top responses.AccessTopResponse
+	if err := json.NewDecoder(w.Body).Decode(&top); err != nil {
+		t.Fatalf("Failed to decode top: %v", err)
+	}
+	if len(top.Totals) != 1 || top.Totals[0].Value != "hot.txt" {
+		t.Errorf("Expected hot.txt to be the most accessed, got %+v", top.Totals)
+	}
 
+	for _, query := range []string{"dimension=bucket", "from=yesterday", "limit=many"} {
+		w = httptest.NewRecorder()
+		endpoints.HandleGetTop(w, httptest.NewRequest("GET", "/api/v1/analytics/access/top?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestRESTAPIReports(t *testing.T) {
+	t.Chdir(t.TempDir())
+	collector := analytics.NewCollector(analytics.Options{})
+	node := fileserver.New(fileserver.Options{
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		Analytics:         collector,
+		Reports:           analytics.NewReports(collector, analytics.ReportsOptions{}),
+	})
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.FileServer = node
+	endpoints := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil))).ReportEndpoints
+	if endpoints == nil {
+		t.Fatal("Expected report endpoints on a node with reports")
+	}
+	ctx := context.Background()
+	if err := node.Store(ctx, "hot.txt", strings.NewReader("content")); err != nil {
+		t.Fatalf("Failed to store file: %v", err)
+	}
+
+	put := func(name, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/v1/analytics/reports/"+name, strings.NewReader(body))
+		req.SetPathValue("name", name)
+		w := httptest.NewRecorder()
+		endpoints.HandlePutReport(w, req)
+		return w
+	}
+	w := put("daily", `{"grouping": "total", "metrics": ["writes", "bytes_written"], "schedule": "@daily"}`)
 	if w.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", w.Code)
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var report analytics.ReportInfo
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if report.Format != analytics.FormatCSV || report.NextRun.IsZero() {
+		t.Errorf("Expected a scheduled CSV report, got %+v", report)
+	}
+	for _, body := range []string{`{"format": "xlsx"}`, `{"schedule": "sometimes"}`, `{"email": ["ops@example.com"]}`, `not json`} {
+		if w := put("bad", body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, w.Code)
+		}
 	}
 
-	if w.Header().Get("Access-Control-Allow-Origin") != "http://localhost:3000" {
-		t.Errorf("Expected CORS header to be set")
+	req := httptest.NewRequest("POST", "/api/v1/analytics/reports/daily/run", nil)
+	req.SetPathValue("name", "daily")
+	w = httptest.NewRecorder()
+	endpoints.HandleRunReport(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var run analytics.ReportRun
+	if err := json.NewDecoder(w.Body).Decode(&run); err != nil {
+		t.Fatalf("Failed to decode run: %v", err)
+	}
+	if !strings.HasPrefix(run.Key, "reports/daily/") || !strings.HasSuffix(run.Key, ".csv") {
+		t.Errorf("Unexpected report key %s", run.Key)
+	}
+	r, err := node.Get(ctx, run.Key)
+	if err != nil {
+		t.Fatalf("Expected the report to be stored: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	if want := "key,writes,bytes_written\nhot.txt,1,7\n"; string(data) != want {
+		t.Errorf("Expected report %q, got %q", want, data)
+	}
+
+	w = httptest.NewRecorder()
+	endpoints.HandleListReports(w, httptest.NewRequest("GET", "/api/v1/analytics/reports", nil))
+	var list responses.ReportListResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode reports: %v", err)
+	}
+	if list.Total != 1 || list.Reports[0].LastKey != run.Key {
+		t.Errorf("Expected the report with its last run, got %+v", list)
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/v1/analytics/reports/daily", nil)
+	req.SetPathValue("name", "daily")
+	w = httptest.NewRecorder()
+	endpoints.HandleDeleteReport(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", w.Code)
+	}
+	req = httptest.NewRequest("POST", "/api/v1/analytics/reports/daily/run", nil)
+	req.SetPathValue("name", "daily")
+	w = httptest.NewRecorder()
+	endpoints.HandleRunReport(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a deleted report, got %d", w.Code)
+	}
+}
+
+func TestRESTAPIAlerts(t *testing.T) {
+	t.Chdir(t.TempDir())
+	node := fileserver.New(fileserver.Options{
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		Alerts:            alerting.NewEngine(alerting.Options{}),
+	})
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.FileServer = node
+	endpoints := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil))).AlertEndpoints
+	if endpoints == nil {
+		t.Fatal("Expected alert endpoints on a node with alerting")
+	}
+
+	var notified []map[string]any
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		notified = append(notified, body)
+	}))
+	defer hook.Close()
+
+	send := func(handler http.HandlerFunc, method, path, name, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if name != "" {
+			req.SetPathValue("name", name)
+			req.SetPathValue("id", name)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	if w := send(endpoints.HandlePutChannel, "PUT", "/api/v1/alerts/channels/ops", "ops", `{"type": "webhook", "url": "`+hook.URL+`"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := send(endpoints.HandlePutChannel, "PUT", "/api/v1/alerts/channels/mail", "mail", `{"type": "email", "to": ["ops@example.com"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for email without SMTP, got %d", w.Code)
+	}
+	w := send(endpoints.HandlePutRule, "PUT", "/api/v1/alerts/rules/stale-job", "stale-job", `{"metric": "job_heartbeat", "kind": "absence", "window": "1m", "channels": ["ops"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, body := range []string{`{"metric": "peers", "op": "~"}`, `{"metric": "peers", "op": ">", "channels": ["pager"]}`, `not json`} {
+		if w := send(endpoints.HandlePutRule, "PUT", "/api/v1/alerts/rules/bad", "bad", body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+
+	if w := send(endpoints.HandlePushMetrics, "POST", "/api/v1/alerts/metrics", "", `{"metrics": {"job_heartbeat": 1}}`); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+	w = send(endpoints.HandleListMetrics, "GET", "/api/v1/alerts/metrics", "", "")
+	var metrics responses.MetricListResponse
+	if err := json.NewDecoder(w.Body).Decode(&metrics); err != nil || metrics.Total != 1 || metrics.Metrics[0].Metric != "job_heartbeat" {
+		t.Errorf("Expected the pushed metric, got %+v (%v)", metrics, err)
+	}
+
+	// The heartbeat stops for longer than the window
+	node.Alerts.Evaluate(context.Background(), time.Now().Add(2*time.Minute))
+	w = send(endpoints.HandleListAlerts, "GET", "/api/v1/alerts", "", "")
+	var alerts responses.AlertListResponse
+	if err := json.NewDecoder(w.Body).Decode(&alerts); err != nil {
+		t.Fatalf("Failed to decode alerts: %v", err)
+	}
+	if alerts.Total != 1 || alerts.Alerts[0].State != alerting.StateFiring {
+		t.Errorf("Expected the rule to fire, got %+v", alerts)
+	}
+	if len(notified) != 1 || notified[0]["rule"] != "stale-job" || notified[0]["status"] != "firing" {
+		t.Errorf("Expected a firing notification, got %v", notified)
+	}
+
+	w = send(endpoints.HandleAddSilence, "POST", "/api/v1/alerts/silences", "", `{"rule": "stale-job", "duration": "1h", "comment": "job paused"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var silence alerting.Silence
+	if err := json.NewDecoder(w.Body).Decode(&silence); err != nil || silence.ID == "" {
+		t.Fatalf("Expected a silence with an ID, got %+v (%v)", silence, err)
+	}
+	if w := send(endpoints.HandleAddSilence, "POST", "/api/v1/alerts/silences", "", `{"rule": "missing", "duration": "1h"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a silence of an unknown rule, got %d", w.Code)
+	}
+	if w := send(endpoints.HandleDeleteSilence, "DELETE", "/api/v1/alerts/silences/"+silence.ID, silence.ID, ""); w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", w.Code)
+	}
+
+	if w := send(endpoints.HandleDeleteChannel, "DELETE", "/api/v1/alerts/channels/ops", "ops", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a channel in use, got %d", w.Code)
+	}
+	if w := send(endpoints.HandleDeleteRule, "DELETE", "/api/v1/alerts/rules/stale-job", "stale-job", ""); w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", w.Code)
+	}
+	if w := send(endpoints.HandleDeleteRule, "DELETE", "/api/v1/alerts/rules/stale-job", "stale-job", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a deleted rule, got %d", w.Code)
+	}
+}
+
+func TestRESTAPIPeerACL(t *testing.T) {
+	t.Chdir(t.TempDir())
+	rules, err := acl.New(acl.Options{Rules: []acl.Rule{{Action: acl.Deny, CIDR: "10.9.0.0/16"}}})
+	if err != nil {
+		t.Fatalf("Failed to create ACL: %v", err)
+	}
+	node := fileserver.New(fileserver.Options{
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		PeerACL:           rules,
+	})
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.FileServer = node
+	endpoints := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil))).PeerACLEndpoints
+	if endpoints == nil {
+		t.Fatal("Expected peer ACL endpoints on a node with a peer ACL")
+	}
+
+	send := func(handler http.HandlerFunc, method, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/peers/acl", strings.NewReader(body))
+		req.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	w := send(endpoints.HandleAddRule, "POST", "", `{"action": "deny", "node_id": "evil", "comment": "compromised"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var rule acl.Rule
+	if err := json.NewDecoder(w.Body).Decode(&rule); err != nil || rule.ID == "" {
+		t.Fatalf("Expected a rule with an ID, got %+v (%v)", rule, err)
+	}
+	for _, body := range []string{`{"action": "maybe", "node_id": "x"}`, `{"action": "allow"}`, `{"action": "deny", "cidr": "nowhere"}`, `not json`} {
+		if w := send(endpoints.HandleAddRule, "POST", "", body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+	if err := rules.Check(acl.Peer{Addr: "10.1.0.1:3000", NodeID: "evil"}); err == nil {
+		t.Error("Expected the added rule to deny the node")
+	}
+
+	w = send(endpoints.HandleListRules, "GET", "", "")
+	var list responses.PeerACLRuleListResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil || list.Total != 2 || !list.Rules[0].Static {
+		t.Errorf("Expected the configured and the added rule, got %+v (%v)", list, err)
+	}
+
+	if w := send(endpoints.HandleRemoveRule, "DELETE", "config-1", ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a rule of the configuration, got %d", w.Code)
+	}
+	if w := send(endpoints.HandleRemoveRule, "DELETE", rule.ID, ""); w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", w.Code)
+	}
+	if w := send(endpoints.HandleRemoveRule, "DELETE", rule.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a removed rule, got %d", w.Code)
+	}
+}
+
+func TestRESTAPIMQTTACL(t *testing.T) {
+	t.Chdir(t.TempDir())
+	rules, err := topicacl.New(topicacl.Options{Rules: []topicacl.Rule{{Action: topicacl.Allow, Topic: "devices/{device}/#"}}})
+	if err != nil {
+		t.Fatalf("Failed to create topic ACL: %v", err)
+	}
+	node := fileserver.New(fileserver.Options{
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		TopicACL:          rules,
+	})
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.FileServer = node
+	endpoints := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil))).MQTTACLEndpoints
+	if endpoints == nil {
+		t.Fatal("Expected MQTT ACL endpoints on a node with a topic ACL")
+	}
+
+	send := func(handler http.HandlerFunc, method, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/mqtt/acl", strings.NewReader(body))
+		req.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	w := send(endpoints.HandleAddRule, "POST", "", `{"action": "allow", "access": "subscribe", "tenant": "plant-a", "topic": "tenants/{tenant}/#"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var rule topicacl.Rule
+	if err := json.NewDecoder(w.Body).Decode(&rule); err != nil || rule.ID == "" {
+		t.Fatalf("Expected a rule with an ID, got %+v (%v)", rule, err)
+	}
+	for _, body := range []string{`{"action": "maybe", "topic": "a"}`, `{"action": "allow"}`, `{"action": "deny", "topic": "a/#/b"}`, `not json`} {
+		if w := send(endpoints.HandleAddRule, "POST", "", body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+	client := topicacl.Client{ID: "c1", Device: "sensor-1", Tenant: "plant-a"}
+	if err := rules.Check(client, topicacl.Subscribe, "tenants/plant-a/firmware"); err != nil {
+		t.Errorf("Expected the added rule to allow the subscription: %v", err)
+	}
+
+	w = send(endpoints.HandleListRules, "GET", "", "")
+	var list responses.MQTTACLRuleListResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil || list.Total != 2 || !list.Rules[0].Static {
+		t.Errorf("Expected the configured and the added rule, got %+v (%v)", list, err)
+	}
+
+	if w := send(endpoints.HandleRemoveRule, "DELETE", "config-1", ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a rule of the configuration, got %d", w.Code)
+	}
+	if w := send(endpoints.HandleRemoveRule, "DELETE", rule.ID, ""); w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", w.Code)
+	}
+	if err := rules.Check(client, topicacl.Subscribe, "tenants/plant-a/firmware"); err == nil {
+		t.Error("Expected the subscription to be denied once the rule is removed")
+	}
+}
+
+func TestRESTAPIContentScanning(t *testing.T) {
+	t.Chdir(t.TempDir())
+	scanner := scan.New(scan.Options{DenyTypes: []string{"application/x-msdownload"}, Action: scan.ActionReject})
+	node := fileserver.New(fileserver.Options{
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		Scanner:           scanner,
+	})
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.FileServer = node
+	restServer := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	upload := func(name, content string) *httptest.ResponseRecorder {
+		var form bytes.Buffer
+		writer := multipart.NewWriter(&form)
+		part, _ := writer.CreateFormFile("file", name)
+		_, _ = part.Write([]byte(content))
+		_ = writer.Close()
+		req := httptest.NewRequest("POST", "/api/v1/files", &form)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		restServer.FileEndpoints.HandleUploadFile(w, req)
+		return w
+	}
+
+	w := upload("notes.txt", "meeting notes")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var file responses.FileResponse
+	if err := json.NewDecoder(w.Body).Decode(&file); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if file.Metadata[scan.MetaStatus] != scan.StatusClean || file.Metadata[scan.MetaType] != "text/plain" {
+		t.Errorf("Expected the scan to be recorded as clean text, got %v", file.Metadata)
+	}
+
+	w = upload("setup.exe", "MZ\x90\x00")
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status 422 for an executable, got %d: %s", w.Code, w.Body.String())
+	}
+	if stats := scanner.Stats(); stats.Scanned != 2 || stats.Rejected != 1 {
+		t.Errorf("Expected two scans and one rejection, got %+v", stats)
+	}
+}
+
+func TestRESTAPIKeyRotation(t *testing.T) {
+	t.Chdir(t.TempDir())
+	newNode := func(rate int64) (*fileserver.Server, *endpoints.KeyEndpoints) {
+		keys, err := crypto.NewKeyManagerWithClusterKey(strings.Repeat("5a", 32))
+		if err != nil {
+			t.Fatalf("Failed to create key manager: %v", err)
+		}
+		node := fileserver.New(fileserver.Options{
+			StorageRoot:       "store",
+			PathTransformFunc: storage.CASPathTransformFunc,
+			Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+			KeyManager:        keys,
+			ReencryptionRate:  rate,
+		})
+		if err := node.Start(); err != nil {
+			t.Fatalf("Failed to start node: %v", err)
+		}
+		config := rest.DefaultConfig()
+		config.Port = ":0"
+		config.FileServer = node
+		return node, rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil))).KeyEndpoints
+	}
+	status := func(keys *endpoints.KeyEndpoints) fileserver.KeyRotationStatus {
+		w := httptest.NewRecorder()
+		keys.HandleGetStatus(w, httptest.NewRequest("GET", "/api/v1/keys", nil))
+		var st fileserver.KeyRotationStatus
+		if err := json.NewDecoder(w.Body).Decode(&st); err != nil {
+			t.Fatalf("Failed to decode key status: %v", err)
+		}
+		return st
+	}
+	waitFor := func(keys *endpoints.KeyEndpoints, done func(fileserver.ReencryptionProgress) bool) fileserver.KeyRotationStatus {
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			st := status(keys)
+			if done(st.Reencryption) {
+				return st
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for re-encryption, at %+v", st.Reencryption)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	// A rate of one byte per second lets the first object through on the
+	// initial burst and holds the job at the second
+	node, keys := newNode(1)
+	files := map[string]string{}
+	for i := range 3 {
+		key := fmt.Sprintf("file-%d.txt", i)
+		files[key] = strings.Repeat(key, 60000)
+		if err := node.Store(context.Background(), key, strings.NewReader(files[key])); err != nil {
+			t.Fatalf("Failed to store file: %v", err)
+		}
+	}
+	if st := status(keys); st.Generation != 0 || st.Reencryption.Status != fileserver.ReencryptionIdle {
+		t.Fatalf("Expected generation 0 and no job, got %+v", st)
+	}
+
+	w := httptest.NewRecorder()
+	keys.HandleRotate(w, httptest.NewRequest("POST", "/api/v1/keys/rotate", nil))
+	var rotated fileserver.KeyRotationStatus
+	if err := json.NewDecoder(w.Body).Decode(&rotated); err != nil || w.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202 with the new generation, got %d (%v)", w.Code, err)
+	}
+	if rotated.Generation != 1 || rotated.Reencryption.Status != fileserver.ReencryptionRunning {
+		t.Fatalf("Expected a running job for generation 1, got %+v", rotated)
+	}
+	waitFor(keys, func(p fileserver.ReencryptionProgress) bool { return p.Reencrypted == 1 })
+
+	// Files of both generations are readable, and new writes use the new key
+	files["new.txt"] = "written after the rotation"
+	if err := node.Store(context.Background(), "new.txt", strings.NewReader(files["new.txt"])); err != nil {
+		t.Fatalf("Failed to store file: %v", err)
+	}
+	for key, content := range files {
+		r, err := node.Get(context.Background(), key)
+		if err != nil {
+			t.Fatalf("Failed to read %s mid-rotation: %v", key, err)
+		}
+		if data, _ := io.ReadAll(r); string(data) != content {
+			t.Errorf("Unexpected content of %s mid-rotation", key)
+		}
+	}
+
+	// The job resumes after a restart where it stopped
+	node.Stop()
+	node, keys = newNode(-1)
+	defer node.Stop()
+	st := waitFor(keys, func(p fileserver.ReencryptionProgress) bool { return p.Status == fileserver.ReencryptionCompleted })
+	if st.Generation != 1 || st.Reencryption.Reencrypted != 3 || st.Reencryption.Failed != 0 {
+		t.Errorf("Expected the three older files re-encrypted once, got %+v", st.Reencryption)
+	}
+
+	paths, err := node.Storage().Objects()
+	if err != nil || len(paths) != len(files) {
+		t.Fatalf("Expected %d objects, got %v (%v)", len(files), paths, err)
+	}
+	for _, fullPath := range paths {
+		_, r, err := node.Storage().ReadObject(fullPath)
+		if err != nil {
+			t.Fatalf("Failed to open object: %v", err)
+		}
+		header := make([]byte, crypto.BlobHeaderSize)
+		_, err = io.ReadFull(r, header)
+		_ = r.Close()
+		if gen, ok := crypto.BlobGeneration(header); err != nil || !ok || gen != 1 {
+			t.Errorf("Expected %s encrypted with generation 1, got %d (%v)", fullPath, gen, err)
+		}
+	}
+	for key, content := range files {
+		r, err := node.Get(context.Background(), key)
+		if err != nil {
+			t.Fatalf("Failed to read %s after the rotation: %v", key, err)
+		}
+		if data, _ := io.ReadAll(r); string(data) != content {
+			t.Errorf("Unexpected content of %s after the rotation", key)
+		}
+	}
+}
+
+func TestRESTAPIJobs(t *testing.T) {
+	t.Chdir(t.TempDir())
+	keys, err := crypto.NewKeyManagerWithClusterKey(strings.Repeat("5a", 32))
+	if err != nil {
+		t.Fatalf("Failed to create key manager: %v", err)
+	}
+	node := fileserver.New(fileserver.Options{
+		StorageRoot:         "store",
+		PathTransformFunc:   storage.CASPathTransformFunc,
+		Transport:           netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		KeyManager:          keys,
+		KeyRotationInterval: 24 * time.Hour,
+	})
+	if err := node.Start(); err != nil {
+		t.Fatalf("Failed to start node: %v", err)
+	}
+	defer node.Stop()
+
+	config := rest.DefaultConfig()
+	config.Port = "127.0.0.1:0"
+	config.FileServer = node
+	config.Backup = &backup.Config{Jobs: []*backup.Job{{
+		Name:         "all",
+		Schedule:     "0 * * * *",
+		FullSchedule: "30 2 * * *",
+		Target:       backup.TargetConfig{Type: "dir", Path: t.TempDir()},
+	}}}
+	if err := config.Backup.Validate(); err != nil {
+		t.Fatalf("Invalid backup config: %v", err)
+	}
+	restServer := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	go func() { _ = restServer.Start() }()
+	defer func() { _ = restServer.Stop(context.Background()) }()
+	jobs := restServer.JobEndpoints
+
+	list := func() map[string]scheduler.JobStatus {
+		w := httptest.NewRecorder()
+		jobs.HandleListJobs(w, httptest.NewRequest("GET", "/api/v1/jobs", nil))
+		var resp responses.JobListResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode jobs: %v", err)
+		}
+		byName := make(map[string]scheduler.JobStatus)
+		for _, job := range resp.Jobs {
+			byName[job.Name] = job
+		}
+		return byName
+	}
+	get := func(name string) (int, responses.JobResponse) {
+		req := httptest.NewRequest("GET", "/api/v1/jobs/"+name, nil)
+		req.SetPathValue("name", name)
+		w := httptest.NewRecorder()
+		jobs.HandleGetJob(w, req)
+		var resp responses.JobResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode job: %v", err)
+			}
+		}
+		return w.Code, resp
+	}
+	update := func(name, body string) (int, scheduler.JobStatus) {
+		req := httptest.NewRequest("PATCH", "/api/v1/jobs/"+name, strings.NewReader(body))
+		req.SetPathValue("name", name)
+		w := httptest.NewRecorder()
+		jobs.HandleUpdateJob(w, req)
+		var job scheduler.JobStatus
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&job); err != nil {
+				t.Fatalf("Failed to decode job: %v", err)
+			}
+		}
+		return w.Code, job
+	}
+	run := func(name string) int {
+		req := httptest.NewRequest("POST", "/api/v1/jobs/"+name+"/run", nil)
+		req.SetPathValue("name", name)
+		w := httptest.NewRecorder()
+		jobs.HandleRunJob(w, req)
+		return w.Code
+	}
+
+	// Lifecycle runs and backups join the node's jobs once the API starts
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		all := list()
+		if _, ok := all["backup.all.full"]; ok {
+			for _, name := range []string{"key-rotation", "lifecycle", "backup.all"} {
+				if _, ok := all[name]; !ok {
+					t.Errorf("Expected job %s, got %v", name, all)
+				}
+			}
+			if job := all["key-rotation"]; job.Schedule != "@every 24h0m0s" || job.NextRun.IsZero() {
+				t.Errorf("Expected key rotation every 24h, got %+v", job)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the backup jobs, got %v", all)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if code, _ := get("missing"); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a missing job, got %d", code)
+	}
+	if code := run("missing"); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 running a missing job, got %d", code)
+	}
+	if code, _ := update("backup.all", `{"schedule":"not cron"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid schedule, got %d", code)
+	}
+
+	code, job := update("backup.all", `{"schedule":"@every 6h","paused":true}`)
+	if code != http.StatusOK || job.Schedule != "@every 6h" || job.DefaultSchedule != "0 * * * *" || !job.Paused {
+		t.Errorf("Expected the paused job to run every 6h, got %d %+v", code, job)
+	}
+	if code, job = update("backup.all", `{"schedule":"","paused":false}`); job.Schedule != "0 * * * *" || job.Paused {
+		t.Errorf("Expected the default schedule to be restored, got %d %+v", code, job)
+	}
+
+	// Manual runs go through the scheduler and are recorded
+	generation := keys.Generation()
+	if code := run("key-rotation"); code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", code)
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		_, job := get("key-rotation")
+		if len(job.Runs) > 0 && job.Runs[0].Status != scheduler.Running {
+			if job.Runs[0].Status != scheduler.Succeeded || job.Runs[0].Trigger != scheduler.Manual {
+				t.Errorf("Expected a successful manual run, got %+v", job.Runs[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the key rotation, got %+v", job)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if keys.Generation() != generation+1 {
+		t.Errorf("Expected the key to rotate to generation %d, got %d", generation+1, keys.Generation())
+	}
+}
+
+func TestRESTAPIGrafana(t *testing.T) {
+	t.Chdir(t.TempDir())
+	collector := analytics.NewCollector(analytics.Options{})
+	node := fileserver.New(fileserver.Options{
+		ID:                "node-1",
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		Analytics:         collector,
+	})
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.FileServer = node
+	endpoints := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil))).GrafanaEndpoints
+	if endpoints == nil {
+		t.Fatal("Expected Grafana endpoints on a node")
+	}
+	collector.Record(analytics.Access{Op: analytics.OpWrite, Key: "hot.txt", Tenant: "acme", Bytes: 7})
+
+	post := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "/api/v1/grafana", strings.NewReader(body)))
+		return w
+	}
+	var targets []string
+	if err := json.NewDecoder(post(endpoints.HandleSearch, `{"target": "access_"}`).Body).Decode(&targets); err != nil {
+		t.Fatalf("Failed to decode search: %v", err)
+	}
+	if len(targets) != 6 || targets[0] != "access_bytes_read" {
+		t.Errorf("Expected the six access metrics, got %v", targets)
+	}
+	if err := json.NewDecoder(post(endpoints.HandleSearch, `{"target": "tenant"}`).Body).Decode(&targets); err != nil || len(targets) != 1 || targets[0] != "acme" {
+		t.Errorf("Expected the tenants for a label search, got %v (%v)", targets, err)
+	}
+	var tagValues []responses.GrafanaTagValue
+	if err := json.NewDecoder(post(endpoints.HandleTagValues, `{"key": "key"}`).Body).Decode(&tagValues); err != nil || len(tagValues) != 1 || tagValues[0].Text != "hot.txt" {
+		t.Errorf("Expected the keys as tag values, got %v (%v)", tagValues, err)
+	}
+
+	now := time.Now().UTC()
+	w := post(endpoints.HandleQuery, `{"range": {"from": "`+now.Add(-time.Hour).Format(time.RFC3339)+`", "to": "`+now.Format(time.RFC3339)+`"},
+		"targets": [{"refId": "A", "target": "access_bytes_written{tenant=\"acme\"}"}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var series []grafana.Series
+	if err := json.NewDecoder(w.Body).Decode(&series); err != nil {
+		t.Fatalf("Failed to decode query: %v", err)
+	}
+	if len(series) != 1 || series[0].Target != `access_bytes_written{node="node-1",tenant="acme"}` {
+		t.Fatalf("Expected one series for the tenant, got %+v", series)
+	}
+	var sum float64
+	for _, p := range series[0].Datapoints {
+		sum += p[0]
+	}
+	if sum != 7 {
+		t.Errorf("Expected 7 bytes written, got %v", sum)
+	}
+
+	if w := post(endpoints.HandleQuery, `{"targets": [{"target": "access_reads{region=\"eu\"}"}]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown label, got %d", w.Code)
+	}
+}
+
+func TestRESTAPILifecycle(t *testing.T) {
+	restServer := setupTestServer()
+	if restServer.LifecycleEndpoints == nil {
+		t.Fatal("Expected lifecycle endpoints to be available")
+	}
+	uploadTestFile(t, restServer, "file1", "example")
+
+	// No report before the first run
+	req := httptest.NewRequest("GET", "/api/v1/lifecycle/report", nil)
+	w := httptest.NewRecorder()
+	restServer.LifecycleEndpoints.HandleGetReport(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+
+	// Invalid rules are rejected
+	req = httptest.NewRequest("PUT", "/api/v1/lifecycle/policy", strings.NewReader(`{"rules":[{"id":"tier","transition_after_days":7}]}`))
+	w = httptest.NewRecorder()
+	restServer.LifecycleEndpoints.HandleSetPolicy(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+
+	body := `{"rules":[{"id":"user1-expire","tenant":"user1","expire_after_days":30},{"id":"cold","prefix":"file","transition_after_days":7,"transition_to":"cold"}]}`
+	req = httptest.NewRequest("PUT", "/api/v1/lifecycle/policy", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	restServer.LifecycleEndpoints.HandleSetPolicy(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/lifecycle/policy", nil)
+	w = httptest.NewRecorder()
+	restServer.LifecycleEndpoints.HandleGetPolicy(w, req)
+	var policy responses.LifecyclePolicyResponse
+	if err := json.NewDecoder(w.Body).Decode(&policy); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(policy.Rules) != 2 || policy.Enforcing {
+		t.Errorf("Expected 2 rules reported only, got %+v", policy)
+	}
+
+	// Runs are dry unless asked otherwise; nothing is old enough to act on
+	req = httptest.NewRequest("POST", "/api/v1/lifecycle/run", nil)
+	w = httptest.NewRecorder()
+	restServer.LifecycleEndpoints.HandleRun(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var report lifecycle.Report
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !report.DryRun || report.Objects != 1 || report.Rules != 2 || len(report.Actions) != 0 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/lifecycle/run?dry_run=maybe", nil)
+	w = httptest.NewRecorder()
+	restServer.LifecycleEndpoints.HandleRun(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/lifecycle/report", nil)
+	w = httptest.NewRecorder()
+	restServer.LifecycleEndpoints.HandleGetReport(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+}
+
+func TestRESTAPIBackups(t *testing.T) {
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.Backup = &backup.Config{Jobs: []*backup.Job{{
+		Name:   "all",
+		Target: backup.TargetConfig{Type: "dir", Path: t.TempDir()},
+	}}}
+	if err := config.Backup.Validate(); err != nil {
+		t.Fatalf("Invalid backup config: %v", err)
+	}
+	restServer := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, _ := writer.CreateFormFile("file", "ledger.csv")
+	_, _ = part.Write([]byte("2026-01-01,100"))
+	_ = writer.Close()
+	req := httptest.NewRequest("POST", "/api/v1/files", &form)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	restServer.FileEndpoints.HandleUploadFile(w, req)
+	var file responses.FileResponse
+	if err := json.NewDecoder(w.Body).Decode(&file); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/backups/jobs/missing/run", nil)
+	req.SetPathValue("job", "missing")
+	w = httptest.NewRecorder()
+	restServer.BackupEndpoints.HandleRunJob(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+
+	// Backups run in the background; wait for the job to record a snapshot
+	req = httptest.NewRequest("POST", "/api/v1/backups/jobs/all/run?type=full", nil)
+	req.SetPathValue("job", "all")
+	w = httptest.NewRecorder()
+	restServer.BackupEndpoints.HandleRunJob(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", w.Code, w.Body.String())
+	}
+	var snapshotID string
+	for deadline := time.Now().Add(5 * time.Second); snapshotID == "" && time.Now().Before(deadline); {
+		req = httptest.NewRequest("GET", "/api/v1/backups/jobs", nil)
+		w = httptest.NewRecorder()
+		restServer.BackupEndpoints.HandleListJobs(w, req)
+		var jobs responses.BackupJobListResponse
+		if err := json.NewDecoder(w.Body).Decode(&jobs); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(jobs.Jobs) != 1 {
+			t.Fatalf("Expected 1 job, got %+v", jobs)
+		}
+		if jobs.Jobs[0].LastError != "" {
+			t.Fatalf("Backup failed: %s", jobs.Jobs[0].LastError)
+		}
+		snapshotID = jobs.Jobs[0].LastSnapshot
+		time.Sleep(10 * time.Millisecond)
+	}
+	if snapshotID == "" {
+		t.Fatal("Backup did not complete")
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/backups/jobs/all/snapshots/"+snapshotID+"/verify", nil)
+	req.SetPathValue("job", "all")
+	req.SetPathValue("id", snapshotID)
+	w = httptest.NewRecorder()
+	restServer.BackupEndpoints.HandleVerifySnapshot(w, req)
+	var verify backup.VerifyReport
+	if err := json.NewDecoder(w.Body).Decode(&verify); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	// The seeded example file has no content, so only the upload is backed up
+	if !verify.Healthy || verify.Checked != 1 {
+		t.Errorf("Unexpected verify report: %+v", verify)
+	}
+
+	// Lose the file, then restore it from the snapshot
+	req = httptest.NewRequest("DELETE", "/api/v1/files?key="+file.Key, nil)
+	w = httptest.NewRecorder()
+	restServer.FileEndpoints.HandleDeleteFile(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/backups/jobs/all/restore", strings.NewReader(`{"at":"`+time.Now().Add(time.Minute).UTC().Format(time.RFC3339)+`"}`))
+	req.SetPathValue("job", "all")
+	w = httptest.NewRecorder()
+	restServer.BackupEndpoints.HandleRestore(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var restore backup.RestoreReport
+	if err := json.NewDecoder(w.Body).Decode(&restore); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if restore.Snapshot != snapshotID || restore.Restored != 1 {
+		t.Errorf("Unexpected restore report: %+v", restore)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/files/"+file.Key+"/content", nil)
+	req.SetPathValue("key", file.Key)
+	w = httptest.NewRecorder()
+	restServer.FileEndpoints.HandleDownloadFile(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "2026-01-01,100" {
+		t.Errorf("Expected restored content, got %d: %q", w.Code, w.Body.String())
+	}
+
+	// Nothing to restore before the first snapshot
+	req = httptest.NewRequest("POST", "/api/v1/backups/jobs/all/restore", strings.NewReader(`{"at":"2000-01-01T00:00:00Z"}`))
+	req.SetPathValue("job", "all")
+	w = httptest.NewRecorder()
+	restServer.BackupEndpoints.HandleRestore(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestRESTAPISnapshots(t *testing.T) {
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.Backup = &backup.Config{Jobs: []*backup.Job{{
+		Name:   "all",
+		Target: backup.TargetConfig{Type: "dir", Path: t.TempDir()},
+	}}}
+	if err := config.Backup.Validate(); err != nil {
+		t.Fatalf("Invalid backup config: %v", err)
+	}
+	config.Snapshots = &snapshot.Config{Path: t.TempDir()}
+	restServer := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, _ := writer.CreateFormFile("file", "plan.txt")
+	_, _ = part.Write([]byte("first draft"))
+	_ = writer.Close()
+	req := httptest.NewRequest("POST", "/api/v1/files", &form)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	restServer.FileEndpoints.HandleUploadFile(w, req)
+	var file responses.FileResponse
+	if err := json.NewDecoder(w.Body).Decode(&file); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/snapshots", strings.NewReader(`{"namespace":"file_","name":"before-cleanup"}`))
+	w = httptest.NewRecorder()
+	restServer.SnapshotEndpoints.HandleCreateSnapshot(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var snap snapshot.Snapshot
+	if err := json.NewDecoder(w.Body).Decode(&snap); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if snap.Files != 1 {
+		t.Errorf("Expected 1 file in the snapshot, got %+v", snap)
+	}
+
+	// Deleting the file preserves the content the snapshot recorded
+	req = httptest.NewRequest("DELETE", "/api/v1/files?key="+file.Key, nil)
+	w = httptest.NewRecorder()
+	restServer.FileEndpoints.HandleDeleteFile(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/snapshots/"+snap.ID+"/files/"+file.Key, nil)
+	req.SetPathValue("id", snap.ID)
+	req.SetPathValue("key", file.Key)
+	w = httptest.NewRecorder()
+	restServer.SnapshotEndpoints.HandleDownloadFile(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "first draft" {
+		t.Errorf("Expected the snapshot content, got %d: %q", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/snapshots/"+snap.ID+"/diff", nil)
+	req.SetPathValue("id", snap.ID)
+	w = httptest.NewRecorder()
+	restServer.SnapshotEndpoints.HandleDiff(w, req)
+	var diff snapshot.Diff
+	if err := json.NewDecoder(w.Body).Decode(&diff); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if diff.Removed != 1 || diff.To != snapshot.Live {
+		t.Errorf("Unexpected diff: %+v", diff)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/snapshots/"+snap.ID+"/backup", strings.NewReader(`{"job":"all","type":"full"}`))
+	req.SetPathValue("id", snap.ID)
+	w = httptest.NewRecorder()
+	restServer.SnapshotEndpoints.HandleBackup(w, req)
+	var backed backup.Snapshot
+	if err := json.NewDecoder(w.Body).Decode(&backed); err != nil {
+		t.Fatalf("Failed to decode response: %v: %s", err, w.Body.String())
+	}
+	if backed.Files != 1 {
+		t.Errorf("Expected the deleted file in the backup, got %+v", backed)
+	}
+
+	// Cloning onto the namespace brings the file back
+	req = httptest.NewRequest("POST", "/api/v1/snapshots/"+snap.ID+"/clone", strings.NewReader(`{"prefix":"file_"}`))
+	req.SetPathValue("id", snap.ID)
+	w = httptest.NewRecorder()
+	restServer.SnapshotEndpoints.HandleClone(w, req)
+	var clone snapshot.CloneReport
+	if err := json.NewDecoder(w.Body).Decode(&clone); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if clone.Cloned != 1 {
+		t.Errorf("Unexpected clone report: %+v", clone)
+	}
+	req = httptest.NewRequest("GET", "/api/v1/files/"+file.Key+"/content", nil)
+	req.SetPathValue("key", file.Key)
+	w = httptest.NewRecorder()
+	restServer.FileEndpoints.HandleDownloadFile(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "first draft" {
+		t.Errorf("Expected cloned content, got %d: %q", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/snapshots/missing", nil)
+	req.SetPathValue("id", "missing")
+	w = httptest.NewRecorder()
+	restServer.SnapshotEndpoints.HandleGetSnapshot(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestRESTAPIDirectories(t *testing.T) {
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	restServer := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	upload := func(path, name, content string) responses.FileResponse {
+		t.Helper()
+		var form bytes.Buffer
+		writer := multipart.NewWriter(&form)
+		part, _ := writer.CreateFormFile("file", name)
+		_, _ = part.Write([]byte(content))
+		_ = writer.WriteField("path", path)
+		_ = writer.Close()
+		req := httptest.NewRequest("POST", "/api/v1/files", &form)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		restServer.FileEndpoints.HandleUploadFile(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+		}
+		var file responses.FileResponse
+		if err := json.NewDecoder(w.Body).Decode(&file); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return file
+	}
+	file := upload("projects/alpha/", "plan.txt", "the plan")
+	if file.Key != "projects/alpha/plan.txt" {
+		t.Fatalf("Expected the file in its folder, got key %q", file.Key)
+	}
+	upload("projects/alpha/notes/monday.txt", "ignored.txt", "notes")
+
+	list := func(path string, recursive bool) directory.Listing {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/v1/directories/"+path+fmt.Sprintf("?recursive=%t", recursive), nil)
+		req.SetPathValue("path", path)
+		w := httptest.NewRecorder()
+		restServer.DirectoryEndpoints.HandleListDirectory(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 listing %q, got %d: %s", path, w.Code, w.Body.String())
+		}
+		var listing directory.Listing
+		if err := json.NewDecoder(w.Body).Decode(&listing); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return listing
+	}
+	alpha := list("projects/alpha", false)
+	if len(alpha.Files) != 1 || len(alpha.Directories) != 1 || alpha.Directories[0].Path != "projects/alpha/notes/" {
+		t.Errorf("Unexpected listing: %+v", alpha)
+	}
+	if all := list("projects", true); all.TotalFiles != 2 {
+		t.Errorf("Expected 2 files below projects, got %+v", all)
+	}
+
+	req := httptest.NewRequest("PUT", "/api/v1/directories/projects/beta", nil)
+	req.SetPathValue("path", "projects/beta")
+	w := httptest.NewRecorder()
+	restServer.DirectoryEndpoints.HandleMakeDirectory(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Renaming the folder moves every key below it without re-uploading
+	req = httptest.NewRequest("POST", "/api/v1/directories/move", strings.NewReader(`{"from":"projects/alpha","to":"archive/alpha"}`))
+	w = httptest.NewRecorder()
+	restServer.DirectoryEndpoints.HandleMove(w, req)
+	var report directory.Report
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if w.Code != http.StatusOK || report.Files != 2 || report.Failed != 0 {
+		t.Fatalf("Unexpected move: %d %+v", w.Code, report)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/files/archive%2Falpha%2Fplan.txt/content", nil)
+	req.SetPathValue("key", "archive/alpha/plan.txt")
+	w = httptest.NewRecorder()
+	restServer.FileEndpoints.HandleDownloadFile(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "the plan" {
+		t.Errorf("Expected the moved content, got %d: %q", w.Code, w.Body.String())
+	}
+
+	root := list("", false)
+	var paths []string
+	for _, d := range root.Directories {
+		paths = append(paths, d.Path)
+	}
+	if strings.Join(paths, ",") != "archive/,projects/" {
+		t.Errorf("Expected archive/ and projects/ at the root, got %v", paths)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/directories/copy", strings.NewReader(`{"from":"archive/alpha/plan.txt","to":"projects/beta/"}`))
+	w = httptest.NewRecorder()
+	restServer.DirectoryEndpoints.HandleCopy(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// A folder holding files is only deleted recursively
+	req = httptest.NewRequest("DELETE", "/api/v1/directories/projects", nil)
+	req.SetPathValue("path", "projects")
+	w = httptest.NewRecorder()
+	restServer.DirectoryEndpoints.HandleDeleteDirectory(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", w.Code)
+	}
+	req = httptest.NewRequest("DELETE", "/api/v1/directories/projects?recursive=true", nil)
+	req.SetPathValue("path", "projects")
+	w = httptest.NewRecorder()
+	restServer.DirectoryEndpoints.HandleDeleteDirectory(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/directories/projects", nil)
+	req.SetPathValue("path", "projects")
+	w = httptest.NewRecorder()
+	restServer.DirectoryEndpoints.HandleListDirectory(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestRESTAPIObjectCopy(t *testing.T) {
+	t.Chdir(t.TempDir())
+	rules, err := policy.New(policy.Options{Policy: policy.Policy{Rules: []policy.Rule{
+		{ID: "tenants", Operations: []policy.Operation{policy.OpCopy, policy.OpMove}, Deny: `source.tenant != tenant && tenant != "archive"`},
+	}}})
+	if err != nil {
+		t.Fatalf("Failed to compile the policy: %v", err)
+	}
+	node := fileserver.New(fileserver.Options{
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		Policy:            rules,
+	})
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.FileServer = node
+	restServer := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, _ := writer.CreateFormFile("file", "report.txt")
+	_, _ = part.Write([]byte("quarterly numbers"))
+	_ = writer.WriteField("path", "acme/report.txt")
+	_ = writer.WriteField("metadata", `{"tenant":"acme"}`)
+	_ = writer.Close()
+	req := httptest.NewRequest("POST", "/api/v1/files", &form)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	restServer.FileEndpoints.HandleUploadFile(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	transfer := func(handler http.HandlerFunc, body string) (*httptest.ResponseRecorder, responses.FileResponse) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/v1/files/copy", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		var file responses.FileResponse
+		if w.Code == http.StatusCreated {
+			if err := json.NewDecoder(w.Body).Decode(&file); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return w, file
+	}
+	content := func(key string) string {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/v1/files/"+key+"/content", nil)
+		req.SetPathValue("key", key)
+		w := httptest.NewRecorder()
+		restServer.FileEndpoints.HandleDownloadFile(w, req)
+		if w.Code != http.StatusOK {
+			return ""
+		}
+		return w.Body.String()
+	}
+
+	w, file := transfer(restServer.FileEndpoints.HandleCopyObject, `{"from":"acme/report.txt","to":"acme/2024/report.txt","metadata":{"year":"2024"}}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if file.Key != "acme/2024/report.txt" || file.Metadata["year"] != "2024" || file.Metadata["tenant"] != "acme" {
+		t.Errorf("Unexpected copy: %+v", file)
+	}
+	if got := content("acme/2024/report.txt"); got != "quarterly numbers" {
+		t.Errorf("Expected the copied content, got %q", got)
+	}
+
+	if w, _ := transfer(restServer.FileEndpoints.HandleCopyObject, `{"from":"acme/report.txt","to":"acme/2024/report.txt"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for an existing destination, got %d", w.Code)
+	}
+	if w, _ := transfer(restServer.FileEndpoints.HandleCopyObject, `{"from":"missing.txt","to":"other.txt"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+	if w, _ := transfer(restServer.FileEndpoints.HandleCopyObject, `{"from":"acme/report.txt","to":"globex/report.txt","tenant":"globex"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a copy to another tenant, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w, file = transfer(restServer.FileEndpoints.HandleMoveObject, `{"from":"acme/2024/report.txt","to":"archive/acme-2024.txt","tenant":"archive"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if file.Metadata["tenant"] != "archive" {
+		t.Errorf("Expected the file to move to the archive tenant, got %v", file.Metadata)
+	}
+	if content("acme/2024/report.txt") != "" || content("archive/acme-2024.txt") != "quarterly numbers" {
+		t.Error("Expected the content under the new key only")
+	}
+
+	// Without a policy nothing allows copies to another tenant
+	plain := rest.NewServer(rest.DefaultConfig(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	uploadTestFile(t, plain, "file1", "example")
+	req = httptest.NewRequest("POST", "/api/v1/files/copy", strings.NewReader(`{"from":"file1","to":"shared/example.txt","tenant":"other"}`))
+	w = httptest.NewRecorder()
+	plain.FileEndpoints.HandleCopyObject(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 without a policy, got %d", w.Code)
+	}
+}
+
+// rejectTemporaryWasm is a WebAssembly module whose hook rejects every
+// request with the reason "temporary files"
+var rejectTemporaryWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x01, 0x16, 0x04, 0x60, 0x00, 0x00, 0x60, 0x02,
+	0x7f, 0x7f, 0x00, 0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x00, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f,
+	0x02, 0x14, 0x01, 0x09, 0x70, 0x65, 0x65, 0x72, 0x76, 0x61, 0x75, 0x6c, 0x74, 0x06, 0x72, 0x65,
+	0x6a, 0x65, 0x63, 0x74, 0x00, 0x01, 0x03, 0x02, 0x01, 0x00, 0x05, 0x03, 0x01, 0x00, 0x01, 0x07,
+	0x11, 0x02, 0x04, 0x68, 0x6f, 0x6f, 0x6b, 0x00, 0x01, 0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79,
+	0x02, 0x00, 0x0a, 0x0a, 0x01, 0x08, 0x00, 0x41, 0x00, 0x41, 0x0f, 0x10, 0x00, 0x0b, 0x0b, 0x15,
+	0x01, 0x00, 0x41, 0x00, 0x0b, 0x0f, 0x74, 0x65, 0x6d, 0x70, 0x6f, 0x72, 0x61, 0x72, 0x79, 0x20,
+	0x66, 0x69, 0x6c, 0x65, 0x73,
+}
+
+func TestRESTAPIHooks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("reject.wasm", rejectTemporaryWasm, 0644); err != nil {
+		t.Fatalf("Failed to write the module: %v", err)
+	}
+	engine, err := hooks.New(hooks.Config{Hooks: []hooks.Hook{
+		{Name: "no-tmp", Point: hooks.PreStore, Module: "reject.wasm", Prefix: "tmp/"},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to compile the hooks: %v", err)
+	}
+	defer engine.Close()
+	node := fileserver.New(fileserver.Options{
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		Hooks:             engine,
+	})
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.FileServer = node
+	restServer := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	upload := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+		var form bytes.Buffer
+		writer := multipart.NewWriter(&form)
+		part, _ := writer.CreateFormFile("file", "a.txt")
+		_, _ = part.Write([]byte("scratch"))
+		_ = writer.WriteField("path", path)
+		_ = writer.Close()
+		req := httptest.NewRequest("POST", "/api/v1/files", &form)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		restServer.FileEndpoints.HandleUploadFile(w, req)
+		return w
+	}
+	if w := upload("tmp/a.txt"); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "temporary files") {
+		t.Errorf("Expected status 403 with the hook's reason, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := upload("docs/a.txt"); w.Code != http.StatusCreated {
+		t.Errorf("Expected status 201 outside the hook's prefix, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/hooks", nil)
+	req.Header.Set("Authorization", "Bearer "+config.AuthToken)
+	w := httptest.NewRecorder()
+	restServer.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var list responses.HookListResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if list.Total != 1 || list.Hooks[0].Name != "no-tmp" || list.Hooks[0].Calls != 1 || list.Hooks[0].Rejections != 1 {
+		t.Errorf("Unexpected hooks: %+v", list)
+	}
+	if got := node.Metrics()["hook_no_tmp_rejections_total"]; got != 1 {
+		t.Errorf("Expected 1 rejection in the metrics, got %v", got)
+	}
+}
+
+func TestRESTAPIComposeObject(t *testing.T) {
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	restServer := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for i, part := range []string{"alpha ", "beta ", "gamma"} {
+		var form bytes.Buffer
+		writer := multipart.NewWriter(&form)
+		fw, _ := writer.CreateFormFile("file", "part")
+		_, _ = fw.Write([]byte(part))
+		_ = writer.WriteField("path", fmt.Sprintf("video.bin.part-%03d", i))
+		_ = writer.Close()
+		req := httptest.NewRequest("POST", "/api/v1/files", &form)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		restServer.FileEndpoints.HandleUploadFile(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	compose := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/v1/files/compose", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		restServer.FileEndpoints.HandleComposeObject(w, req)
+		return w
+	}
+	content := func(key string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/v1/files/"+key+"/content", nil)
+		req.SetPathValue("key", key)
+		w := httptest.NewRecorder()
+		restServer.FileEndpoints.HandleDownloadFile(w, req)
+		return w.Code, w.Body.String()
+	}
+
+	w := compose(`{"sources":["video.bin.part-000","video.bin.part-001","video.bin.part-002"],"to":"video.bin","delete_sources":true}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var file responses.FileResponse
+	if err := json.NewDecoder(w.Body).Decode(&file); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if file.Key != "video.bin" || file.Size != int64(len("alpha beta gamma")) {
+		t.Errorf("Unexpected composed file: %+v", file)
+	}
+	if _, got := content("video.bin"); got != "alpha beta gamma" {
+		t.Errorf("Expected the parts in order, got %q", got)
+	}
+	if code, _ := content("video.bin.part-001"); code != http.StatusNotFound {
+		t.Errorf("Expected the parts to be deleted, got status %d", code)
+	}
+
+	// The destination may be a source, which appends to it
+	if w := compose(`{"sources":["video.bin","video.bin"],"to":"video.bin"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 without overwrite, got %d", w.Code)
+	}
+	if w := compose(`{"sources":["video.bin","video.bin"],"to":"video.bin","overwrite":true}`); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, got := content("video.bin"); got != "alpha beta gammaalpha beta gamma" {
+		t.Errorf("Expected the file twice, got %q", got)
+	}
+
+	if w := compose(`{"sources":["missing"],"to":"out.bin"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a missing source, got %d", w.Code)
+	}
+	if w := compose(`{"sources":[],"to":"out.bin"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without sources, got %d", w.Code)
+	}
+}
+
+func TestRESTAPIBatch(t *testing.T) {
+	restServer := setupTestServer()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/report.csv" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("a,b\n1,2\n"))
+	}))
+	defer origin.Close()
+
+	run := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/v1/batch", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		restServer.BatchEndpoints.HandleBatch(w, req)
+		return w
+	}
+
+	// One at a time, so each operation sees the ones before it
+	w := run(`{"parallelism": 1, "operations": [
+		{"op": "store", "key": "batch/a.txt", "content": "aGVsbG8=", "tags": ["draft"]},
+		{"op": "store", "key": "batch/report.csv", "url": "` + origin.URL + `/report.csv"},
+		{"op": "copy", "from": "batch/a.txt", "to": "batch/b.txt"},
+		{"op": "tag", "key": "batch/b.txt", "add_tags": ["final"], "remove_tags": ["draft"], "set": {"owner": "ops"}},
+		{"op": "delete", "key": "batch/a.txt"},
+		{"op": "delete", "key": "batch/a.txt"},
+		{"op": "store", "key": "batch/missing.csv", "url": "` + origin.URL + `/missing.csv"}
+	]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response responses.BatchResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	statuses := make([]int, len(response.Results))
+	for i, result := range response.Results {
+		statuses[i] = result.Status
+	}
+	want := []int{201, 201, 201, 200, 204, 404, 502}
+	if fmt.Sprint(statuses) != fmt.Sprint(want) {
+		t.Fatalf("Expected statuses %v, got %v: %+v", want, statuses, response.Results)
+	}
+	if response.Succeeded != 5 || response.Failed != 2 {
+		t.Errorf("Expected 5 succeeded and 2 failed, got %d and %d", response.Succeeded, response.Failed)
+	}
+	if f := response.Results[1].File; f == nil || f.Size != int64(len("a,b\n1,2\n")) {
+		t.Errorf("Expected the fetched report, got %+v", f)
+	}
+	if f := response.Results[3].File; f == nil || fmt.Sprint(f.Tags) != "[final]" || f.Metadata["owner"] != "ops" {
+		t.Errorf("Expected the copy retagged, got %+v", f)
+	}
+	if r := response.Results[2]; r.Op != "copy" || r.Key != "batch/b.txt" || r.Index != 2 {
+		t.Errorf("Expected the result to name the copy, got %+v", r)
+	}
+
+	// Many operations at once each get their own result
+	var ops []string
+	for i := range 50 {
+		ops = append(ops, fmt.Sprintf(`{"op": "store", "key": "batch/many/%d", "content": "eA=="}`, i))
+	}
+	w = run(`{"parallelism": 16, "operations": [` + strings.Join(ops, ",") + `]}`)
+	response = responses.BatchResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Succeeded != 50 || response.Results[49].Key != "batch/many/49" {
+		t.Errorf("Expected 50 stores in order, got %d succeeded", response.Succeeded)
+	}
+
+	// Malformed operations fail the batch before anything runs
+	for _, body := range []string{
+		`{"operations": []}`,
+		`{"operations": [{"op": "rename", "key": "x"}]}`,
+		`{"operations": [{"op": "store", "key": "x"}]}`,
+		`{"operations": [{"op": "store", "key": "x", "url": "file:///etc/passwd"}]}`,
+		`{"operations": [{"op": "store", "key": "batch/ok", "content": "eA=="}, {"op": "copy", "from": "x"}]}`,
+	} {
+		if w := run(body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, w.Code)
+		}
+	}
+	req := httptest.NewRequest("GET", "/api/v1/files?key=batch/ok", nil)
+	w = httptest.NewRecorder()
+	restServer.FileEndpoints.HandleGetFile(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected nothing of a rejected batch to run, got status %d", w.Code)
+	}
+}
+
+func TestRESTAPIConditionalRequests(t *testing.T) {
+	restServer := setupTestServer()
+
+	upload := func(content string, header map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		var form bytes.Buffer
+		writer := multipart.NewWriter(&form)
+		fw, _ := writer.CreateFormFile("file", "notes.txt")
+		_, _ = fw.Write([]byte(content))
+		_ = writer.WriteField("path", "cond/notes.txt")
+		_ = writer.Close()
+		req := httptest.NewRequest("POST", "/api/v1/files", &form)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		restServer.FileEndpoints.HandleUploadFile(w, req)
+		return w
+	}
+	request := func(method, target string, handler http.HandlerFunc, header map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, nil)
+		req.SetPathValue("key", "cond/notes.txt")
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	// If-None-Match: * only creates
+	w := upload("v1", map[string]string{"If-None-Match": "*"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	v1 := w.Header().Get("ETag")
+	if v1 != `"`+fmt.Sprintf("%x", sha256.Sum256([]byte("v1")))+`"` {
+		t.Fatalf("Expected the content hash as ETag, got %q", v1)
+	}
+	if w := upload("again", map[string]string{"If-None-Match": "*"}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412 for an existing file, got %d", w.Code)
+	}
+
+	// Reads revalidate with If-None-Match
+	get := restServer.FileEndpoints.HandleGetFile
+	if w := request("GET", "/api/v1/files/get?key=cond/notes.txt", get, nil); w.Code != http.StatusOK || w.Header().Get("ETag") != v1 {
+		t.Errorf("Expected status 200 with the ETag, got %d and %q", w.Code, w.Header().Get("ETag"))
+	}
+	if w := request("GET", "/api/v1/files/get?key=cond/notes.txt", get, map[string]string{"If-None-Match": v1}); w.Code != http.StatusNotModified {
+		t.Errorf("Expected status 304, got %d", w.Code)
+	}
+	download := restServer.FileEndpoints.HandleDownloadFile
+	if w := request("GET", "/api/v1/files/cond/notes.txt/content", download, map[string]string{"If-None-Match": v1}); w.Code != http.StatusNotModified {
+		t.Errorf("Expected status 304 for the content, got %d", w.Code)
+	}
+	if w := request("GET", "/api/v1/files/cond/notes.txt/content", download, map[string]string{"If-Match": `"stale"`}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412 for the content, got %d", w.Code)
+	}
+
+	// A writer holding a stale ETag does not overwrite a newer version
+	w = upload("v2", map[string]string{"If-Match": v1})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	v2 := w.Header().Get("ETag")
+	if w := upload("lost update", map[string]string{"If-Match": v1}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412 for a stale ETag, got %d", w.Code)
+	}
+	if w := request("GET", "/api/v1/files/cond/notes.txt/content", download, nil); w.Body.String() != "v2" {
+		t.Errorf("Expected v2 to be kept, got %q", w.Body.String())
+	}
+
+	del := restServer.FileEndpoints.HandleDeleteFile
+	if w := request("DELETE", "/api/v1/files?key=cond/notes.txt", del, map[string]string{"If-Match": v1}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412 deleting with a stale ETag, got %d", w.Code)
+	}
+	if w := request("DELETE", "/api/v1/files?key=cond/notes.txt", del, map[string]string{"If-Match": v2}); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := upload("v3", map[string]string{"If-Match": "*"}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412 replacing a deleted file, got %d", w.Code)
+	}
+}
+
+func TestRESTAPIGeoReplicationStatus(t *testing.T) {
+	restServer := setupTestServer()
+	if restServer.GeoReplicationEndpoints != nil {
+		t.Fatal("Expected geo-replication to be disabled by default")
+	}
+
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.GeoReplication = &georeplication.Config{
+		ClusterID: "eu-west",
+		Secret:    "integration-secret",
+		Targets:   []georeplication.TargetConfig{{Name: "us-east", URL: "http://127.0.0.1:1"}},
+	}
+	restServer = rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if restServer.GeoReplicationEndpoints == nil {
+		t.Fatal("Expected geo-replication endpoints")
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/replication/status", nil)
+	w := httptest.NewRecorder()
+	restServer.GeoReplicationEndpoints.HandleStatus(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var status georeplication.Status
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if status.ClusterID != "eu-west" || status.Policy != georeplication.PolicyLastWriterWins {
+		t.Errorf("Unexpected status %+v", status)
+	}
+	if len(status.Targets) != 1 || status.Targets[0].Name != "us-east" || status.Targets[0].Connected {
+		t.Errorf("Expected one disconnected target, got %+v", status.Targets)
+	}
+}
+func TestRESTAPIPeers(t *testing.T) {
+	restServer := setupTestServer()
+
+	// Test list peers
+	req := httptest.NewRequest("GET", "/api/v1/peers", nil)
+	w := httptest.NewRecorder()
+
+	restServer.PeerEndpoints.HandleListPeers(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	var response responses.PeerListResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if len(response.Peers) == 0 {
+		t.Error("Expected at least one peer in response")
+	}
+
+	// Test get peer
+	req = httptest.NewRequest("GET", "/api/v1/peers/get?id=peer1", nil)
+	w = httptest.NewRecorder()
+
+	restServer.PeerEndpoints.HandleGetPeer(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	var peerResponse responses.PeerResponse
+	if err := json.NewDecoder(w.Body).Decode(&peerResponse); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if peerResponse.ID != "peer1" {
+		t.Errorf("Expected ID 'peer1', got '%s'", peerResponse.ID)
+	}
+}
+
+func TestRESTAPIAddPeer(t *testing.T) {
+	restServer := setupTestServer()
+
+	request := requests.PeerAddRequest{
+		Address: "192.168.1.100",
+		Port:    8080,
+		Metadata: map[string]string{
+			"region": "us-east",
+		},
+	}
+
+	body, _ := json.Marshal(request)
+	req := httptest.NewRequest("POST", "/api/v1/peers", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	restServer.PeerEndpoints.HandleAddPeer(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d", w.Code)
+	}
+
+	var response responses.PeerResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if response.Address != "192.168.1.100" {
+		t.Errorf("Expected address '192.168.1.100', got '%s'", response.Address)
+	}
+}
+
+func TestRESTAPIWebhook(t *testing.T) {
+	restServer := setupTestServer()
+
+	request := requests.WebhookRequest{
+		Event:     "file.uploaded",
+		Timestamp: time.Now().Unix(),
+		Data: map[string]interface{}{
+			"file_id": "test123",
+			"size":    1024,
+		},
+		Source: "test-client",
+	}
+
+	body, _ := json.Marshal(request)
+	req := httptest.NewRequest("POST", "/webhook", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	restServer.SystemEndpoints.HandleWebhook(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+}
+
+func TestRESTAPIDocs(t *testing.T) {
+	restServer := setupTestServer()
+
+	req := httptest.NewRequest("GET", "/docs", nil)
+	w := httptest.NewRecorder()
+
+	restServer.SystemEndpoints.HandleDocs(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	contentType := w.Header().Get("Content-Type")
+	if contentType != "text/html" {
+		t.Errorf("Expected content type 'text/html', got '%s'", contentType)
+	}
+}
+
+func TestRESTAPISwaggerJSON(t *testing.T) {
+	restServer := setupTestServer()
+
+	req := httptest.NewRequest("GET", "/swagger.json", nil)
+	w := httptest.NewRecorder()
+
+	restServer.SystemEndpoints.HandleSwaggerJSON(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	contentType := w.Header().Get("Content-Type")
+	if contentType != "application/json" {
+		t.Errorf("Expected content type 'application/json', got '%s'", contentType)
+	}
+
+	var swaggerSpec map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&swaggerSpec); err != nil {
+		t.Fatalf("Failed to decode swagger spec: %v", err)
+	}
+
+	if swaggerSpec["openapi"] != "3.1.0" {
+		t.Errorf("Expected OpenAPI version '3.1.0', got '%v'", swaggerSpec["openapi"])
+	}
+}
+
+func TestRESTAPICORS(t *testing.T) {
+	restServer := setupTestServer()
+
+	req := httptest.NewRequest("OPTIONS", "/health", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	w := httptest.NewRecorder()
+
+	restServer.CORSMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	if w.Header().Get("Access-Control-Allow-Origin") != "http://localhost:3000" {
+		t.Errorf("Expected CORS header to be set")
+	}
+}
+
+func TestRESTAPIBrowserProtection(t *testing.T) {
+	handler := setupTestServer().Handler()
+
+	// Security headers on every response, a locked down CSP except for docs
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Header().Get("X-Content-Type-Options") != "nosniff" || w.Header().Get("X-Frame-Options") != "DENY" {
+		t.Errorf("Expected security headers, got %v", w.Header())
+	}
+	if csp := w.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "default-src 'none'") {
+		t.Errorf("Expected locked down CSP, got '%s'", csp)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/docs", nil))
+	if csp := w.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "https://unpkg.com") {
+		t.Errorf("Expected the docs CSP, got '%s'", csp)
+	}
+
+	// Preflight requests are answered without authentication
+	req := httptest.NewRequest("OPTIONS", "/api/v1/files", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	req.Header.Set("Access-Control-Request-Method", "DELETE")
+	req.Header.Set("Access-Control-Request-Headers", "Authorization")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "http://localhost:3000" {
+		t.Errorf("Expected preflight to pass, got %d %v", w.Code, w.Header())
+	}
+
+	// Cross-origin writes without credentials of their own are rejected
+	req = httptest.NewRequest("POST", "/api/v1/peers", strings.NewReader("{}"))
+	req.Header.Set("Origin", "https://evil.example")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+}
+
+// ldapAuth stands in for the auth provider of a plugin
+type ldapAuth struct{}
+
+func (ldapAuth) Authenticate(ctx context.Context, creds plugin.Credentials) (*plugin.Identity, error) {
+	switch {
+	case creds.Token == "sso-token", creds.Username == "alice" && creds.Password == "wonderland":
+		return &plugin.Identity{Subject: "alice"}, nil
+	case creds.Token == "broken":
+		return nil, errors.New("directory unreachable")
+	default:
+		return nil, plugin.ErrDenied
+	}
+}
+
+func TestRESTAPIAuthProviders(t *testing.T) {
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.AuthProviders = []plugin.Auth{ldapAuth{}}
+	handler := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil))).Handler()
+
+	do := func(setAuth func(*http.Request)) int {
+		req := httptest.NewRequest("GET", "/api/v1/files", nil)
+		setAuth(req)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	// The configured token still works, and the provider admits its own
+	// tokens and passwords
+	for name, setAuth := range map[string]func(*http.Request){
+		"static token":   bearer(config.AuthToken),
+		"provider token": bearer("sso-token"),
+		"password":       func(req *http.Request) { req.SetBasicAuth("alice", "wonderland") },
+	} {
+		if code := do(setAuth); code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d", name, code)
+		}
+	}
+	for name, setAuth := range map[string]func(*http.Request){
+		"denied token":   bearer("mallory"),
+		"failing check":  bearer("broken"),
+		"wrong password": func(req *http.Request) { req.SetBasicAuth("alice", "guess") },
+		"unknown scheme": func(req *http.Request) { req.Header.Set("Authorization", "Digest x") },
+		"no credentials": func(req *http.Request) {},
+	} {
+		if code := do(setAuth); code != http.StatusUnauthorized {
+			t.Errorf("%s: expected status 401, got %d", name, code)
+		}
+	}
+}
+
+func TestRESTAPIRequestIDAndErrorModel(t *testing.T) {
+	handler := setupTestServer().Handler()
+
+	// The client's request ID is kept and echoed
+	req := httptest.NewRequest("GET", "/health", nil)
+	req.Header.Set("X-Request-ID", "trace-7")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if got := w.Header().Get("X-Request-ID"); got != "trace-7" {
+		t.Errorf("Expected request ID 'trace-7', got '%s'", got)
+	}
+
+	// Without one the server generates it
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Header().Get("X-Request-ID") == "" {
+		t.Error("Expected a generated request ID")
+	}
+
+	// Errors come back in the error model, carrying the request ID
+	req = httptest.NewRequest("GET", "/api/v1/files", nil)
+	req.Header.Set("X-Request-ID", "trace-8")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected JSON error, got '%s'", ct)
+	}
+	var apiErr struct {
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		RequestID string `json:"request_id"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&apiErr); err != nil {
+		t.Fatalf("Failed to decode error: %v", err)
+	}
+	if apiErr.Code != "unauthenticated" || apiErr.Message != "Missing authorization header" || apiErr.RequestID != "trace-8" {
+		t.Errorf("Unexpected error %+v", apiErr)
+	}
+}
+
+func TestRESTAPIThumbnails(t *testing.T) {
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.Media = &media.Config{Sizes: []int{32, 256}}
+	restServer := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	var picture bytes.Buffer
+	if err := png.Encode(&picture, img); err != nil {
+		t.Fatalf("Failed to encode image: %v", err)
+	}
+	upload := func(key string, data []byte) responses.FileResponse {
+		t.Helper()
+		var form bytes.Buffer
+		writer := multipart.NewWriter(&form)
+		fw, _ := writer.CreateFormFile("file", key)
+		_, _ = fw.Write(data)
+		_ = writer.WriteField("path", key)
+		_ = writer.Close()
+		req := httptest.NewRequest("POST", "/api/v1/files", &form)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		restServer.FileEndpoints.HandleUploadFile(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+		}
+		var file responses.FileResponse
+		if err := json.NewDecoder(w.Body).Decode(&file); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return file
+	}
+	thumbnail := func(key, query string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/v1/files/"+key+"/thumbnail"+query, nil)
+		req.SetPathValue("key", key)
+		w := httptest.NewRecorder()
+		restServer.MediaEndpoints.HandleGetThumbnail(w, req)
+		return w
+	}
+
+	file := upload("photos/wide.png", picture.Bytes())
+	if file.Metadata[media.MetaWidth] != "400" || file.Metadata[media.MetaHeight] != "200" {
+		t.Errorf("Expected the dimensions in the metadata, got %v", file.Metadata)
+	}
+
+	w := thumbnail("photos/wide.png", "?size=32")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("Expected a JPEG, got %q", ct)
+	}
+	thumb, err := jpeg.DecodeConfig(w.Body)
+	if err != nil {
+		t.Fatalf("Failed to decode thumbnail: %v", err)
+	}
+	if thumb.Width != 32 || thumb.Height != 16 {
+		t.Errorf("Expected a 32x16 thumbnail, got %dx%d", thumb.Width, thumb.Height)
+	}
+
+	if w := thumbnail("photos/wide.png", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected the default size, got status %d", w.Code)
+	}
+	if w := thumbnail("photos/wide.png", "?size=100"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a size not made, got %d", w.Code)
+	}
+	if w := thumbnail("missing.png", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+	upload("notes.txt", []byte("plain text"))
+	if w := thumbnail("notes.txt", ""); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status 415, got %d", w.Code)
+	}
+}
+
+func TestRESTAPIStreaming(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	// The fake ffprobe describes a 10 second 1080p video, and the fake
+	// ffmpeg prints its arguments as the segment
+	dir := t.TempDir()
+	ffprobe := `#!/bin/sh
+echo '{"streams":[{"codec_type":"video","width":1920,"height":1080}],"format":{"duration":"10.0"}}'
+`
+	if err := os.WriteFile(filepath.Join(dir, "ffprobe"), []byte(ffprobe), 0o755); err != nil {
+		t.Fatalf("Failed to write ffprobe: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte("#!/bin/sh\necho \"$@\"\n"), 0o755); err != nil {
+		t.Fatalf("Failed to write ffmpeg: %v", err)
+	}
+
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.Media = &media.Config{FFmpeg: filepath.Join(dir, "ffmpeg"), SegmentSeconds: 4}
+	restServer := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, _ := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="file"; filename="trip.mp4"`},
+		"Content-Type":        {"video/mp4"},
+	})
+	_, _ = part.Write([]byte("not really a video"))
+	_ = writer.WriteField("path", "movies/trip.mp4")
+	_ = writer.Close()
+	req := httptest.NewRequest("POST", "/api/v1/files", &form)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	restServer.FileEndpoints.HandleUploadFile(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	get := func(handler http.HandlerFunc, profile, segment string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/v1/files/movies%2Ftrip.mp4/stream/", nil)
+		req.SetPathValue("key", "movies/trip.mp4")
+		req.SetPathValue("profile", profile)
+		req.SetPathValue("segment", segment)
+		maps.Copy(req.Header, header)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	w = get(restServer.MediaEndpoints.HandleGetPlaylist, "", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != media.PlaylistType {
+		t.Errorf("Expected an HLS playlist, got %q", ct)
+	}
+	for _, want := range []string{"RESOLUTION=640x360", "360p/index.m3u8", "720p/index.m3u8"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("Expected %q in the master playlist:\n%s", want, w.Body.String())
+		}
+	}
+
+	w = get(restServer.MediaEndpoints.HandleGetPlaylist, "720p", "", nil)
+	if w.Code != http.StatusOK || strings.Count(w.Body.String(), "#EXTINF") != 3 {
+		t.Errorf("Expected 3 segments, got %d:\n%s", w.Code, w.Body.String())
+	}
+
+	w = get(restServer.MediaEndpoints.HandleGetSegment, "720p", "1.ts", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	segment := w.Body.String()
+	if !strings.Contains(segment, "-ss 4 ") || !strings.Contains(segment, "-b:v 2800k") {
+		t.Errorf("Unexpected ffmpeg arguments: %s", segment)
+	}
+	w = get(restServer.MediaEndpoints.HandleGetSegment, "720p", "1.ts", http.Header{"Range": {"bytes=0-9"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != segment[:10] {
+		t.Errorf("Expected the first 10 bytes, got %d %q", w.Code, w.Body.String())
+	}
+
+	for _, c := range []struct{ profile, segment string }{{"720p", "3.ts"}, {"1080p", "0.ts"}, {"720p", "first.ts"}} {
+		if w := get(restServer.MediaEndpoints.HandleGetSegment, c.profile, c.segment, nil); w.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for %s/%s, got %d", c.profile, c.segment, w.Code)
+		}
+	}
+}
+
+func TestRESTAPIJSONDocuments(t *testing.T) {
+	schemas, err := jsondoc.NewSchemas(jsondoc.Config{Schemas: []jsondoc.SchemaRule{{
+		Prefix: "users/",
+		Schema: map[string]any{
+			"type":     "object",
+			"required": []string{"name"},
+			"properties": map[string]any{
+				"name": map[string]any{"type": "string"},
+				"age":  map[string]any{"type": "integer", "minimum": 0},
+			},
+		},
+	}}}, "")
+	if err != nil {
+		t.Fatalf("Failed to compile schemas: %v", err)
+	}
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.JSONSchemas = schemas
+	restServer := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if restServer.JSONEndpoints == nil {
+		t.Fatal("Expected the JSON document API to be enabled")
+	}
+
+	do := func(handler http.HandlerFunc, method, key, contentType, body string, headers map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, "/api/v1/json/"+key, strings.NewReader(body))
+		req.SetPathValue("key", key)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	put := func(key, body string, headers map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		return do(restServer.JSONEndpoints.HandlePutJSON, "PUT", key, jsondoc.ContentType, body, headers)
+	}
+	patch := func(key, contentType, body string, headers map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		return do(restServer.JSONEndpoints.HandlePatchJSON, "PATCH", key, contentType, body, headers)
+	}
+
+	w := put("users/ada", `{"name": "Ada", "age": 36}`, map[string]string{"If-None-Match": "*"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Body.String() != `{"age":36,"name":"Ada"}` {
+		t.Errorf("Expected the normalized document, got %s", w.Body.String())
+	}
+	first := w.Header().Get("ETag")
+	if first == "" {
+		t.Fatal("Expected an ETag")
+	}
+	if w := put("users/ada", `{"name":"Ada"}`, map[string]string{"If-None-Match": "*"}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412 creating an existing document, got %d", w.Code)
+	}
+
+	w = put("users/ada", `{"name":"Ada","age":37}`, map[string]string{"If-Match": first})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	second := w.Header().Get("ETag")
+	if second == first {
+		t.Error("Expected the ETag to change with the document")
+	}
+	if w := put("users/ada", `{"name":"Ada","age":38}`, map[string]string{"If-Match": first}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412 for a stale ETag, got %d", w.Code)
+	}
+
+	w = patch("users/ada", jsondoc.MergePatchType, `{"email":"ada@example.com","age":null}`, map[string]string{"If-Match": second})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Body.String() != `{"email":"ada@example.com","name":"Ada"}` {
+		t.Errorf("Unexpected merge patch result %s", w.Body.String())
+	}
+	w = patch("users/ada", jsondoc.PatchType, `[{"op":"test","path":"/name","value":"Ada"},{"op":"add","path":"/roles","value":["admin"]}]`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Body.String() != `{"email":"ada@example.com","name":"Ada","roles":["admin"]}` {
+		t.Errorf("Unexpected JSON Patch result %s", w.Body.String())
+	}
+	if w := patch("users/ada", jsondoc.PatchType, `[{"op":"test","path":"/name","value":"Grace"}]`, nil); w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a failed test, got %d", w.Code)
+	}
+	if w := patch("users/ada", jsondoc.PatchType, `[{"op":"remove","path":"/name"}]`, nil); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 for a schema violation, got %d", w.Code)
+	}
+	if w := patch("users/ada", jsondoc.ContentType, `{}`, nil); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status 415 for a document as a patch, got %d", w.Code)
+	}
+	if w := put("users/bob", `{"age":-1}`, nil); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 for a schema violation, got %d", w.Code)
+	}
+	if w := put("notes/todo", `not json`, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid JSON, got %d", w.Code)
+	}
+
+	w = do(restServer.JSONEndpoints.HandleGetJSON, "GET", "users/ada", "", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != jsondoc.ContentType {
+		t.Errorf("Expected content type %s, got %q", jsondoc.ContentType, ct)
+	}
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	fw, _ := writer.CreateFormFile("file", "notes.txt")
+	_, _ = fw.Write([]byte("plain text"))
+	_ = writer.WriteField("path", "notes.txt")
+	_ = writer.Close()
+	req := httptest.NewRequest("POST", "/api/v1/files", &form)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w = httptest.NewRecorder()
+	restServer.FileEndpoints.HandleUploadFile(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do(restServer.JSONEndpoints.HandleGetJSON, "GET", "notes.txt", "", "", nil); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status 415 for a file that is not JSON, got %d", w.Code)
+	}
+
+	if w := do(restServer.JSONEndpoints.HandleDeleteJSON, "DELETE", "users/ada", "", "", map[string]string{"If-Match": second}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412 deleting with a stale ETag, got %d", w.Code)
+	}
+	if w := do(restServer.JSONEndpoints.HandleDeleteJSON, "DELETE", "users/ada", "", "", nil); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do(restServer.JSONEndpoints.HandleGetJSON, "GET", "users/ada", "", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 after delete, got %d", w.Code)
+	}
+}
+
+func TestRESTAPILogs(t *testing.T) {
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.Logs = &streamlog.Config{}
+	restServer := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if restServer.LogEndpoints == nil {
+		t.Fatal("Expected logs to be enabled")
+	}
+	handler := restServer.Handler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+config.AuthToken)
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	base := server.URL + "/api/v1/logs/audit"
+
+	resp, err := http.Post(base+"/records", endpoints.NDJSONType, strings.NewReader("{\"user\":\"ada\"}\n\n{\"user\":\"bob\"}\n"))
+	if err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	var appended responses.LogRecordsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&appended); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+	}
+	if len(appended.Records) != 2 || appended.Records[1].Offset != 1 || appended.NextOffset != 2 {
+		t.Errorf("Expected offsets 0 and 1, got %+v", appended)
+	}
+
+	// A follower gets the stored records, then those appended later
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", base+"/records?offset=1&follow=true", nil)
+	follow, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to follow: %v", err)
+	}
+	defer func() { _ = follow.Body.Close() }()
+	if ct := follow.Header.Get("Content-Type"); ct != endpoints.NDJSONType {
+		t.Errorf("Expected NDJSON, got %q", ct)
+	}
+	dec := json.NewDecoder(follow.Body)
+	var rec streamlog.Record
+	if err := dec.Decode(&rec); err != nil || rec.Offset != 1 || string(rec.Data) != `{"user":"bob"}` {
+		t.Fatalf("Expected record 1, got %+v (%v)", rec, err)
+	}
+	resp, err = http.Post(base+"/records", "text/plain", strings.NewReader("plain"))
+	if err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	_ = resp.Body.Close()
+	if err := dec.Decode(&rec); err != nil || rec.Offset != 2 || string(rec.Data) != "plain" {
+		t.Fatalf("Expected the appended record, got %+v (%v)", rec, err)
+	}
+
+	resp, err = http.Get(base + "/records?offset=1&limit=1")
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	var read responses.LogRecordsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&read); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	_ = resp.Body.Close()
+	if len(read.Records) != 1 || read.Records[0].Offset != 1 || read.NextOffset != 2 {
+		t.Errorf("Expected record 1 and next offset 2, got %+v", read)
+	}
+
+	var list responses.LogListResponse
+	resp, err = http.Get(server.URL + "/api/v1/logs")
+	if err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	_ = resp.Body.Close()
+	if list.Total != 1 || list.Logs[0].Name != "audit" || list.Logs[0].NextOffset != 3 {
+		t.Errorf("Expected the audit log with 3 records, got %+v", list)
+	}
+
+	for _, c := range []struct {
+		method, url string
+		status      int
+	}{
+		{"GET", server.URL + "/api/v1/logs/missing", http.StatusNotFound},
+		{"GET", base + "/records?since=yesterday", http.StatusBadRequest},
+		{"POST", server.URL + "/api/v1/logs/.hidden/records", http.StatusBadRequest},
+		{"DELETE", base + "/records", http.StatusBadRequest},
+		{"DELETE", base, http.StatusNoContent},
+		{"GET", base + "/records", http.StatusNotFound},
+	} {
+		req, _ := http.NewRequest(c.method, c.url, strings.NewReader("x"))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", c.method, c.url, err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("%s %s: expected status %d, got %d", c.method, c.url, c.status, resp.StatusCode)
+		}
+	}
+	// Deleting the log ends the follow
+	if err := dec.Decode(&rec); err == nil {
+		t.Error("Expected the follow to end with the log")
+	}
+}
+
+func TestRESTAPIDeviceEnrollment(t *testing.T) {
+	ca, err := devices.New(devices.Options{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create the device CA: %v", err)
+	}
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.FileServer = fileserver.New(fileserver.Options{
+		StorageRoot:       t.TempDir(),
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		Devices:           ca,
+	})
+	handler := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil))).Handler()
+	do := func(method, path, token string, body io.Reader, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, body)
+		maps.Copy(req.Header, header)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/api/v1/devices", config.AuthToken, strings.NewReader(`{"id":"sensor-1"}`), nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var registered responses.DeviceSecretResponse
+	if err := json.NewDecoder(w.Body).Decode(&registered); err != nil || registered.Secret == "" {
+		t.Fatalf("Expected the device secret, got %v: %+v", err, registered)
+	}
+
+	// EST needs no API token: devices prove their own secret
+	w = do("GET", "/.well-known/est/cacerts", "", nil, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Transfer-Encoding") != "base64" {
+		t.Fatalf("Expected the CA certificates, got %d", w.Code)
+	}
+	der, _ := base64.StdEncoding.DecodeString(w.Body.String())
+	if certs, err := devices.ParseCertsOnly(der); err != nil || len(certs) != 1 || !certs[0].Equal(ca.Certificate()) {
+		t.Fatalf("Expected the device CA, got %v", err)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csr, err := devices.NewCSR(key, "sensor-1", "")
+	if err != nil {
+		t.Fatalf("Failed to create the request: %v", err)
+	}
+	enroll := func(path, secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(base64.StdEncoding.EncodeToString(csr)))
+		req.Header.Set("Content-Type", "application/pkcs10")
+		req.SetBasicAuth("sensor-1", secret)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	if w := enroll("/.well-known/est/simpleenroll", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong secret to be refused, got %d", w.Code)
+	}
+	w = enroll("/.well-known/est/simpleenroll", registered.Secret)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	der, _ = base64.StdEncoding.DecodeString(w.Body.String())
+	certs, err := devices.ParseCertsOnly(der)
+	if err != nil || len(certs) != 1 {
+		t.Fatalf("Expected the device certificate, got %v", err)
+	}
+	if id, err := ca.Verify(certs[0]); err != nil || id != "sensor-1" {
+		t.Errorf("Expected a certificate of sensor-1, got %q: %v", id, err)
+	}
+	if w := enroll("/.well-known/est/simplereenroll", registered.Secret); w.Code != http.StatusOK {
+		t.Errorf("Expected the renewal to succeed, got %d", w.Code)
+	}
+
+	// Revoking the device lists its certificates in the CRL
+	if w := do("DELETE", "/api/v1/devices/sensor-1", "", nil, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the admin API to need the token, got %d", w.Code)
+	}
+	if w := do("DELETE", "/api/v1/devices/sensor-1", config.AuthToken, nil, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", w.Code)
+	}
+	w = do("GET", "/.well-known/est/crl", "", nil, nil)
+	crl, err := x509.ParseRevocationList(w.Body.Bytes())
+	if err != nil || len(crl.RevokedCertificateEntries) != 2 {
+		t.Fatalf("Expected both certificates in the CRL, got %v", err)
+	}
+	if w := enroll("/.well-known/est/simpleenroll", registered.Secret); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked device to be refused, got %d", w.Code)
+	}
+}
+
+func TestRESTAPILwM2M(t *testing.T) {
+	t.Chdir(t.TempDir())
+	server := lwm2m.New(lwm2m.Options{})
+	node := fileserver.New(fileserver.Options{
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		LwM2M:             server,
+	})
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.FileServer = node
+	endpoints := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil))).LwM2MEndpoints
+	if endpoints == nil {
+		t.Fatal("Expected LwM2M endpoints on a node with an LwM2M server")
+	}
+	if _, err := server.Register(context.Background(), lwm2m.RegisterRequest{Endpoint: "sensor-1", Links: "</1/0>,</3/0>", Addr: "192.0.2.1:5683"}); err != nil {
+		t.Fatalf("Failed to register device: %v", err)
+	}
+
+	send := func(handler http.HandlerFunc, method, endpoint, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/lwm2m/clients", strings.NewReader(body))
+		req.SetPathValue("endpoint", endpoint)
+		req.SetPathValue("path", path)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	w := send(endpoints.HandleListClients, "GET", "", "", "")
+	var list responses.LwM2MClientListResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil || list.Total != 1 || list.Clients[0].Endpoint != "sensor-1" {
+		t.Errorf("Expected the registered device, got %+v (%v)", list, err)
+	}
+	if w := send(endpoints.HandleGetClient, "GET", "sensor-1", "", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", w.Code)
+	}
+	if w := send(endpoints.HandleGetClient, "GET", "sensor-2", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a device that is not registered, got %d", w.Code)
+	}
+
+	// Without the CoAP API serving, devices cannot be reached
+	if w := send(endpoints.HandleRead, "GET", "sensor-1", "3/0/9", ""); w.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected 504, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := send(endpoints.HandleRead, "GET", "sensor-1", "3/x", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid path, got %d", w.Code)
+	}
+	if w := send(endpoints.HandleWrite, "PUT", "sensor-1", "3/0/0", `{"value": "Acme"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a read-only resource, got %d", w.Code)
+	}
+	if w := send(endpoints.HandleUpdateFirmware, "POST", "sensor-1", "", `{"package_uri": "coap://fw.example/v2.bin"}`); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for a device without the Firmware Update object, got %d", w.Code)
+	}
+	if w := send(endpoints.HandleCancelObservation, "DELETE", "sensor-1", "3/0/9", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a path that is not observed, got %d", w.Code)
+	}
+
+	w = send(endpoints.HandleListObjects, "GET", "", "", "")
+	var objects responses.LwM2MObjectListResponse
+	if err := json.NewDecoder(w.Body).Decode(&objects); err != nil || len(objects.Objects) == 0 || objects.Objects[0].ID != 1 {
+		t.Errorf("Expected the object model, got %+v (%v)", objects, err)
+	}
+}
+
+func TestRESTAPIFirmware(t *testing.T) {
+	t.Chdir(t.TempDir())
+	manager, err := firmware.New(firmware.Options{Dir: "firmware"})
+	if err != nil {
+		t.Fatalf("Failed to create the firmware distribution: %v", err)
+	}
+	node := fileserver.New(fileserver.Options{
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		Firmware:          manager,
+	})
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.FileServer = node
+	fw := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil))).FirmwareEndpoints
+	if fw == nil {
+		t.Fatal("Expected firmware endpoints on a node with a firmware distribution")
+	}
+
+	send := func(handler http.HandlerFunc, method, target, body string, values map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for name, value := range values {
+			req.SetPathValue(name, value)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	data := bytes.Repeat([]byte("firmware"), 1000)
+	w := send(fw.HandleUploadImage, "POST", "/api/v1/firmware/images?name=sensor&version=2.0.0", string(data), nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var img firmware.Image
+	if err := json.NewDecoder(w.Body).Decode(&img); err != nil || img.Version != "2.0.0" || img.Size != int64(len(data)) {
+		t.Fatalf("Expected the signed image, got %+v (%v)", img, err)
+	}
+	if w := send(fw.HandleUploadImage, "POST", "/api/v1/firmware/images?name=sensor&version=2.0.0", "other", nil); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a version uploaded before, got %d", w.Code)
+	}
+
+	// Devices verify the image they download against the signing key
+	w = send(fw.HandleSigningKey, "GET", "/api/v1/firmware/key", "", nil)
+	var signingKey firmware.SigningKey
+	if err := json.NewDecoder(w.Body).Decode(&signingKey); err != nil {
+		t.Fatalf("Failed to decode the signing key: %v", err)
+	}
+	block, _ := pem.Decode([]byte(signingKey.PublicKey))
+	if block == nil {
+		t.Fatal("Expected a PEM public key")
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse the signing key: %v", err)
+	}
+	w = send(fw.HandleImageContent, "GET", "/api/v1/firmware/images/"+img.ID+"/content", "", map[string]string{"id": img.ID})
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), data) {
+		t.Fatalf("Expected the image, got %d", w.Code)
+	}
+	if w.Header().Get(endpoints.FirmwareSignatureHeader) != img.Signature || w.Header().Get(endpoints.FirmwareKeyIDHeader) != signingKey.KeyID {
+		t.Errorf("Expected the signature headers, got %v", w.Header())
+	}
+	if err := firmware.Verify(publicKey.(ed25519.PublicKey), img, w.Body.Bytes()); err != nil {
+		t.Errorf("Expected the image to verify: %v", err)
+	}
+
+	// A canary ring of one device
+	body := `{"image_id":"` + img.ID + `","failure_threshold":50,"rings":[{"name":"canary","devices":["sensor-1"]},{"name":"all","percent":100}]}`
+	w = send(fw.HandleCreateRollout, "POST", "/api/v1/firmware/rollouts", body, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var rollout firmware.Rollout
+	if err := json.NewDecoder(w.Body).Decode(&rollout); err != nil || rollout.Released != 1 {
+		t.Fatalf("Expected the first ring released, got %+v (%v)", rollout, err)
+	}
+	check := func(device string) *httptest.ResponseRecorder {
+		return send(fw.HandleCheckUpdate, "GET", "/api/v1/firmware/devices/"+device+"/manifest?version=1.0.0", "", map[string]string{"device": device})
+	}
+	w = check("sensor-1")
+	var manifest firmware.Manifest
+	if err := json.NewDecoder(w.Body).Decode(&manifest); err != nil || manifest.Image.ID != img.ID || manifest.RolloutID != rollout.ID {
+		t.Fatalf("Expected the canary to be offered the image, got %+v (%v)", manifest, err)
+	}
+	if w := check("sensor-2"); w.Code != http.StatusNoContent {
+		t.Errorf("Expected no update outside the canary, got %d", w.Code)
+	}
+	if w := send(fw.HandleDeleteImage, "DELETE", "/api/v1/firmware/images/"+img.ID, "", map[string]string{"id": img.ID}); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for an image being rolled out, got %d", w.Code)
+	}
+
+	status := func(device, state string) *httptest.ResponseRecorder {
+		return send(fw.HandleReportStatus, "POST", "/api/v1/firmware/devices/"+device+"/status", `{"image_id":"`+img.ID+`","state":"`+state+`"}`, map[string]string{"device": device})
+	}
+	if w := status("sensor-1", "installed"); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := check("sensor-1"); w.Code != http.StatusNoContent {
+		t.Errorf("Expected no update once installed, got %d", w.Code)
+	}
+
+	// The next ring reaches the rest of the fleet; failures pause it
+	if w := send(fw.HandleAdvanceRollout, "POST", "/api/v1/firmware/rollouts/"+rollout.ID+"/advance", "", map[string]string{"id": rollout.ID}); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := check("sensor-2"); w.Code != http.StatusOK {
+		t.Fatalf("Expected the update once the ring is released, got %d", w.Code)
+	}
+	status("sensor-2", "failed")
+	w = send(fw.HandleGetRollout, "GET", "/api/v1/firmware/rollouts/"+rollout.ID, "", map[string]string{"id": rollout.ID})
+	var got responses.FirmwareRolloutResponse
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("Failed to decode the rollout: %v", err)
+	}
+	if got.Rollout.State != firmware.RolloutPaused || got.Rollout.Devices[firmware.StateInstalled] != 1 || got.Rollout.Devices[firmware.StateFailed] != 1 || len(got.Devices) != 2 {
+		t.Errorf("Expected the rollout paused with one install and one failure, got %+v", got)
+	}
+	if w := check("sensor-3"); w.Code != http.StatusNoContent {
+		t.Errorf("Expected no update while paused, got %d", w.Code)
+	}
+	if w := send(fw.HandleGetImage, "GET", "/api/v1/firmware/images/missing", "", map[string]string{"id": "missing"}); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+}
+
+func TestRESTAPIQueue(t *testing.T) {
+	t.Chdir(t.TempDir())
+	var mu sync.Mutex
+	accept := false
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !accept {
+			http.Error(w, "gone", http.StatusGone)
+		}
+	}))
+	defer hook.Close()
+
+	jobs := queue.New(queue.Options{Path: filepath.Join("state", "queue.json")})
+	node := fileserver.New(fileserver.Options{
+		StorageRoot:       "store",
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		Queue:             jobs,
+	})
+	if err := node.Start(); err != nil {
+		t.Fatalf("Failed to start node: %v", err)
+	}
+	defer node.Stop()
+
+	config := rest.DefaultConfig()
+	config.Port = "127.0.0.1:0"
+	config.FileServer = node
+	restServer := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	qe := restServer.QueueEndpoints
+	if qe == nil {
+		t.Fatal("Expected queue endpoints on a node")
+	}
+	send := func(handler http.HandlerFunc, method, target string, values map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		for name, value := range values {
+			req.SetPathValue(name, value)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	dead := func() []queue.Job {
+		w := send(qe.HandleListJobs, "GET", "/api/v1/queue/jobs?state=dead&kind=webhook", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp responses.QueueJobListResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode jobs: %v", err)
+		}
+		return resp.Jobs
+	}
+	settled := func() bool {
+		st := jobs.Stats()
+		return st.Pending == 0 && st.Running == 0
+	}
+
+	// The node delivers queued webhooks; rejected ones end up dead
+	if err := notify.Deliver(context.Background(), jobs, nil, notify.Webhook{URL: hook.URL, ContentType: "application/json", Body: []byte("{}")}); err != nil {
+		t.Fatalf("Failed to queue webhook: %v", err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for len(dead()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	letters := dead()
+	if len(letters) != 1 || !strings.Contains(letters[0].LastError, "410") {
+		t.Fatalf("Expected one dead webhook, got %+v", letters)
+	}
+	id := letters[0].ID
+
+	w := send(qe.HandleGetStats, "GET", "/api/v1/queue", nil)
+	var stats queue.Stats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil || stats.Dead != 1 {
+		t.Fatalf("Expected one dead job in the stats, got %+v (%v)", stats, err)
+	}
+	if w := send(qe.HandleListJobs, "GET", "/api/v1/queue/jobs?state=lost", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown state, got %d", w.Code)
+	}
+	if w := send(qe.HandleGetJob, "GET", "/api/v1/queue/jobs/missing", map[string]string{"id": "missing"}); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+
+	// Requeued jobs run again with their attempts back
+	mu.Lock()
+	accept = true
+	mu.Unlock()
+	if w := send(qe.HandleRequeueJob, "POST", "/api/v1/queue/jobs/"+id+"/requeue", map[string]string{"id": id}); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	deadline = time.Now().Add(10 * time.Second)
+	for !settled() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if w := send(qe.HandleGetJob, "GET", "/api/v1/queue/jobs/"+id, map[string]string{"id": id}); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the delivered job to be gone, got %d", w.Code)
+	}
+	if w := send(qe.HandleRequeueJob, "POST", "/api/v1/queue/jobs/"+id+"/requeue", map[string]string{"id": id}); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+
+	// Only dead jobs are requeued; pending ones may be dropped
+	pending, err := jobs.Enqueue("thumbnail", map[string]string{"key": "a.png"}, queue.EnqueueOptions{Delay: time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to queue job: %v", err)
+	}
+	if w := send(qe.HandleRequeueJob, "POST", "/api/v1/queue/jobs/"+pending.ID+"/requeue", map[string]string{"id": pending.ID}); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409, got %d", w.Code)
+	}
+	if w := send(qe.HandleDeleteJob, "DELETE", "/api/v1/queue/jobs/"+pending.ID, map[string]string{"id": pending.ID}); w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", w.Code)
+	}
+	w = send(qe.HandleRequeueDead, "POST", "/api/v1/queue/dead/requeue", nil)
+	var requeued responses.QueueRequeueResponse
+	if err := json.NewDecoder(w.Body).Decode(&requeued); err != nil || requeued.Requeued != 0 {
+		t.Errorf("Expected nothing to requeue, got %+v (%v)", requeued, err)
+	}
+}
+
+func TestRESTAPIQuery(t *testing.T) {
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	restServer := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, name := range []string{"a.txt", "b.txt", "c.csv"} {
+		var form bytes.Buffer
+		writer := multipart.NewWriter(&form)
+		part, _ := writer.CreateFormFile("file", name)
+		_, _ = part.Write([]byte("content of " + name))
+		_ = writer.WriteField("path", "reports/")
+		_ = writer.Close()
+		req := httptest.NewRequest("POST", "/api/v1/files", &form)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		restServer.FileEndpoints.HandleUploadFile(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	query := func(sql string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(requests.QueryRequest{SQL: sql})
+		req := httptest.NewRequest("POST", "/api/v1/query", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+config.AuthToken)
+		w := httptest.NewRecorder()
+		restServer.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	w := query("SELECT split_part(key, '.', 2) AS ext, COUNT(*), SUM(size) FROM objects WHERE key LIKE 'reports/%' GROUP BY ext ORDER BY ext")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var res responses.QueryResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	want := [][]any{{"csv", float64(1), float64(len("content of c.csv"))}, {"txt", float64(2), float64(2 * len("content of a.txt"))}}
+	if fmt.Sprint(res.Columns) != "[ext COUNT(*) SUM(size)]" || fmt.Sprint(res.Rows) != fmt.Sprint(want) || res.Total != 2 {
+		t.Fatalf("Unexpected result %+v", res)
+	}
+
+	for _, sql := range []string{"DELETE FROM objects", "SELECT nope FROM objects", "SELECT key FROM"} {
+		if w := query(sql); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q, got %d: %s", sql, w.Code, w.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/query/tables", nil)
+	req.Header.Set("Authorization", "Bearer "+config.AuthToken)
+	w = httptest.NewRecorder()
+	restServer.Handler().ServeHTTP(w, req)
+	var tables responses.QueryTableListResponse
+	if err := json.NewDecoder(w.Body).Decode(&tables); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if tables.Total != 2 || tables.Tables[0].Name != "objects" || tables.Tables[1].Name != "versions" {
+		t.Fatalf("Unexpected tables %+v", tables)
+	}
+}
+
+func TestRESTAPIExports(t *testing.T) {
+	dir := t.TempDir()
+	exporter, err := export.New(export.Config{Exports: []export.Export{
+		{Name: "objects", Dataset: export.Objects, Format: export.FormatCSV, Target: &backup.TargetConfig{Type: "dir", Path: dir}},
+		{Name: "accesses", Dataset: export.Accesses},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create the exports: %v", err)
+	}
+	store := metadata.NewStore(metadata.StoreOpts{})
+	if _, err := store.Put(metadata.FileRecord{Key: "reports/q1.pdf", Size: 42, Owner: "acme"}); err != nil {
+		t.Fatalf("Failed to record the file: %v", err)
+	}
+	node := fileserver.New(fileserver.Options{
+		StorageRoot:       filepath.Join(t.TempDir(), "store"),
+		PathTransformFunc: storage.CASPathTransformFunc,
+		Transport:         netp2p.NewTCPTransport(netp2p.TCPTransportOpts{ListenAddr: ":0"}),
+		Metadata:          store,
+		Analytics:         analytics.NewCollector(analytics.Options{}),
+		Exports:           exporter,
+	})
+	config := rest.DefaultConfig()
+	config.Port = ":0"
+	config.FileServer = node
+	restServer := rest.NewServer(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+config.AuthToken)
+		w := httptest.NewRecorder()
+		restServer.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/api/v1/exports/objects/run")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var run export.Run
+	if err := json.NewDecoder(w.Body).Decode(&run); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(run.Key)))
+	if err != nil {
+		t.Fatalf("Expected the export in the target: %v", err)
+	}
+	if run.Rows != 1 || !strings.Contains(string(data), "reports/q1.pdf,,42,") {
+		t.Errorf("Unexpected export of %d rows: %s", run.Rows, data)
+	}
+
+	if w := do("POST", "/api/v1/exports/accesses/run"); w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 without an access log, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("POST", "/api/v1/exports/missing/run"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = do("GET", "/api/v1/exports")
+	var list responses.ExportListResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if list.Total != 2 || list.Exports[0].LastKey != run.Key || list.Exports[0].Destination != "dir:"+dir ||
+		list.Exports[1].Range != "24h0m0s" || list.Exports[1].LastError == "" {
+		t.Errorf("Unexpected exports %+v", list)
 	}
 }
@@ -2,8 +2,10 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 
+	"github.com/Skpow1234/Peervault/internal/codec"
 	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
 )
 
@@ -134,3 +136,97 @@ func (sr *slowReader) Read(p []byte) (n int, err error) {
 	// Read only one byte at a time
 	return sr.buf.Read(p[:1])
 }
+
+func TestMessageEnvelope(t *testing.T) {
+	payload := []byte("test message")
+
+	// Messages to peers on version 2 travel in envelopes
+	buf := new(bytes.Buffer)
+	if err := netp2p.NewFrameWriter(buf).WriteEnvelope(netp2p.ProtocolVersion2, codec.IDGob, payload); err != nil {
+		t.Fatalf("failed to write envelope: %v", err)
+	}
+	if buf.Bytes()[0] != netp2p.IncomingEnvelope {
+		t.Fatalf("expected an envelope frame, got type %d", buf.Bytes()[0])
+	}
+	var rpc netp2p.RPC
+	if err := (netp2p.LengthPrefixedDecoder{}).Decode(buf, &rpc); err != nil {
+		t.Fatalf("failed to decode envelope: %v", err)
+	}
+	if rpc.Version != netp2p.ProtocolVersion2 || rpc.Codec != codec.IDGob || !bytes.Equal(rpc.Payload, payload) {
+		t.Errorf("unexpected message: version %d, codec %d, payload %q", rpc.Version, rpc.Codec, rpc.Payload)
+	}
+
+	// From version 3 on, envelopes name their codec
+	buf.Reset()
+	if err := netp2p.NewFrameWriter(buf).WriteEnvelope(netp2p.ProtocolVersion3, codec.IDCBOR, payload); err != nil {
+		t.Fatalf("failed to write envelope: %v", err)
+	}
+	if err := (netp2p.LengthPrefixedDecoder{}).Decode(buf, &rpc); err != nil {
+		t.Fatalf("failed to decode envelope: %v", err)
+	}
+	if rpc.Version != netp2p.ProtocolVersion3 || rpc.Codec != codec.IDCBOR || !bytes.Equal(rpc.Payload, payload) {
+		t.Errorf("unexpected message: version %d, codec %d, payload %q", rpc.Version, rpc.Codec, rpc.Payload)
+	}
+
+	// Bare messages are version 1
+	buf.Reset()
+	if err := netp2p.NewFrameWriter(buf).WriteMessage(payload); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	if err := (netp2p.LengthPrefixedDecoder{}).Decode(buf, &rpc); err != nil {
+		t.Fatalf("failed to decode message: %v", err)
+	}
+	if rpc.Version != netp2p.ProtocolVersion1 {
+		t.Errorf("expected version 1, got %d", rpc.Version)
+	}
+
+	// Envelopes of versions or codecs this node does not speak are refused
+	buf.Reset()
+	if err := netp2p.NewFrameWriter(buf).WriteEnvelope(netp2p.ProtocolVersion3, 99, payload); err != nil {
+		t.Fatalf("failed to write envelope: %v", err)
+	}
+	if err := (netp2p.LengthPrefixedDecoder{}).Decode(buf, &rpc); err == nil {
+		t.Error("envelope of an unknown codec accepted")
+	}
+	buf.Reset()
+	if err := netp2p.NewFrameWriter(buf).WriteEnvelope(netp2p.CurrentProtocolVersion+1, codec.IDGob, payload); err != nil {
+		t.Fatalf("failed to write envelope: %v", err)
+	}
+	if err := (netp2p.LengthPrefixedDecoder{}).Decode(buf, &rpc); err == nil {
+		t.Error("envelope of an unknown version accepted")
+	}
+}
+
+func TestMalformedFrames(t *testing.T) {
+	for name, frame := range map[string][]byte{
+		"unknown type":                 {0x07, 0, 0, 0, 0},
+		"above the maximum size":       {netp2p.IncomingMessage, 0xFF, 0xFF, 0xFF, 0xFF},
+		"empty envelope":               {netp2p.IncomingEnvelope, 0, 0, 0, 0},
+		"envelope without codec":       {netp2p.IncomingEnvelope, 0, 0, 0, 1, netp2p.ProtocolVersion3},
+		"envelope of version 1":        {netp2p.IncomingEnvelope, 0, 0, 0, 1, netp2p.ProtocolVersion1},
+		"envelope of an unknown codec": {netp2p.IncomingEnvelope, 0, 0, 0, 2, netp2p.ProtocolVersion3, 99},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rpc := netp2p.RPC{From: "stale", Payload: []byte("stale"), Stream: true}
+			err := (netp2p.LengthPrefixedDecoder{}).Decode(bytes.NewReader(frame), &rpc)
+			if !errors.Is(err, netp2p.ErrMalformedFrame) {
+				t.Fatalf("expected ErrMalformedFrame, got %v", err)
+			}
+			if rpc.From != "" || rpc.Stream {
+				t.Errorf("fields of the previous message left: %+v", rpc)
+			}
+		})
+	}
+
+	// Frames cut short are failed reads, not malformed ones
+	err := (netp2p.LengthPrefixedDecoder{}).Decode(bytes.NewReader([]byte{netp2p.IncomingMessage, 0, 0, 0, 9, 'a'}), &netp2p.RPC{})
+	if err == nil || errors.Is(err, netp2p.ErrMalformedFrame) {
+		t.Errorf("expected a read error, got %v", err)
+	}
+
+	// Decoders with a MaxSize refuse larger frames
+	err = (netp2p.LengthPrefixedDecoder{MaxSize: 4}).Decode(bytes.NewReader([]byte{netp2p.IncomingMessage, 0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o'}), &netp2p.RPC{})
+	if !errors.Is(err, netp2p.ErrMalformedFrame) {
+		t.Errorf("expected ErrMalformedFrame, got %v", err)
+	}
+}
@@ -2,11 +2,16 @@ package main
 
 import (
 	"bytes"
+	"encoding/binary"
+	"io"
 	"net"
 	"os"
+	"slices"
 	"testing"
 	"time"
 
+	"github.com/Skpow1234/Peervault/internal/codec"
+	"github.com/Skpow1234/Peervault/internal/crypto"
 	netp2p "github.com/Skpow1234/Peervault/internal/transport/p2p"
 )
 
@@ -109,6 +114,30 @@ func TestHandshakeMessageSerialization(t *testing.T) {
 	if !bytes.Equal(deserialized.Signature, msg.Signature) {
 		t.Errorf("Signature mismatch")
 	}
+
+	// The join token of a joining node follows the codecs
+	msg.MinVersion, msg.MaxVersion, msg.Codecs, msg.JoinToken = 1, 3, []uint8{1}, "token-id"
+	deserialized, err = netp2p.DeserializeHandshakeMessage(netp2p.SerializeHandshakeMessage(msg))
+	if err != nil {
+		t.Fatalf("failed to deserialize: %v", err)
+	}
+	if deserialized.JoinToken != msg.JoinToken || !bytes.Equal(deserialized.Codecs, msg.Codecs) {
+		t.Errorf("unexpected round trip %+v", deserialized)
+	}
+}
+
+func TestHandshakeMessageNodeID(t *testing.T) {
+	// Node IDs end up in logs and peer tables, so only printable UTF-8 is accepted
+	for _, id := range []string{"", "node\n", "node\x00", "\xff\xfe"} {
+		data := netp2p.SerializeHandshakeMessage(netp2p.HandshakeMessage{NodeID: id, Timestamp: 1, Signature: []byte{1}})
+		if _, err := netp2p.DeserializeHandshakeMessage(data); err == nil {
+			t.Errorf("node ID %q accepted", id)
+		}
+	}
+	data := netp2p.SerializeHandshakeMessage(netp2p.HandshakeMessage{NodeID: "nœud-1", Timestamp: 1, Signature: []byte{1}})
+	if _, err := netp2p.DeserializeHandshakeMessage(data); err != nil {
+		t.Errorf("failed to deserialize: %v", err)
+	}
 }
 
 func TestHandshakeSignature(t *testing.T) {
@@ -131,3 +160,250 @@ func TestHandshakeSignature(t *testing.T) {
 		t.Error("signature verification should fail with wrong token")
 	}
 }
+
+// TestHandshakeSignatureCoversNegotiation checks a message whose protocol
+// versions or codecs are rewritten in transit, to downgrade the link, fails
+// both signatures
+func TestHandshakeSignatureCoversNegotiation(t *testing.T) {
+	authToken := "test-token"
+	identity, err := crypto.NewIdentity()
+	if err != nil {
+		t.Fatalf("failed to create identity: %v", err)
+	}
+	msg := netp2p.NewHandshakeMessage(identity.ID(), authToken, identity)
+	if !netp2p.VerifyHandshakeMessage(msg, authToken) || netp2p.VerifyHandshakeIdentity(msg) != nil {
+		t.Fatal("valid handshake rejected")
+	}
+
+	tampered := map[string]func(*netp2p.HandshakeMessage){
+		"max version lowered": func(m *netp2p.HandshakeMessage) { m.MaxVersion = netp2p.ProtocolVersion2 },
+		"min version raised":  func(m *netp2p.HandshakeMessage) { m.MinVersion = m.MaxVersion },
+		"versions stripped":   func(m *netp2p.HandshakeMessage) { m.MinVersion, m.MaxVersion = 0, 0 },
+		"codecs reordered": func(m *netp2p.HandshakeMessage) {
+			m.Codecs = slices.Clone(m.Codecs)
+			slices.Reverse(m.Codecs)
+		},
+		"codecs stripped":     func(m *netp2p.HandshakeMessage) { m.Codecs = nil },
+		"codec list narrowed": func(m *netp2p.HandshakeMessage) { m.Codecs = []uint8{codec.IDGob} },
+	}
+	for name, tamper := range tampered {
+		t.Run(name, func(t *testing.T) {
+			forged := msg
+			tamper(&forged)
+			if netp2p.VerifyHandshakeMessage(forged, authToken) {
+				t.Error("tampered versions pass the signature")
+			}
+			if netp2p.VerifyHandshakeIdentity(forged) == nil {
+				t.Error("tampered versions pass the identity signature")
+			}
+		})
+	}
+}
+
+func TestIdentityHandshake(t *testing.T) {
+	t.Setenv("PEERVAULT_AUTH_TOKEN", "test-auth-token-123")
+
+	serverIdentity, err := crypto.NewIdentity()
+	if err != nil {
+		t.Fatalf("failed to create identity: %v", err)
+	}
+	clientIdentity, err := crypto.NewIdentity()
+	if err != nil {
+		t.Fatalf("failed to create identity: %v", err)
+	}
+
+	// Both ends write before reading, so the pair needs buffered connections
+	client, server := tcpPair(t)
+
+	serverPeer := netp2p.NewTCPPeer(server, false)
+	done := make(chan error, 1)
+	go func() { done <- netp2p.IdentityHandshakeFunc(serverIdentity)(serverPeer) }()
+
+	clientPeer := netp2p.NewTCPPeer(client, true)
+	if err := netp2p.IdentityHandshakeFunc(clientIdentity)(clientPeer); err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server handshake failed: %v", err)
+	}
+
+	// Both ends know each other by their key-derived IDs
+	if clientPeer.NodeID() != serverIdentity.ID() {
+		t.Errorf("client sees node %s, expected %s", clientPeer.NodeID(), serverIdentity.ID())
+	}
+	if serverPeer.NodeID() != clientIdentity.ID() {
+		t.Errorf("server sees node %s, expected %s", serverPeer.NodeID(), clientIdentity.ID())
+	}
+	if !bytes.Equal(serverPeer.PublicKey(), clientIdentity.PublicKey()) {
+		t.Error("server did not record the client's identity key")
+	}
+}
+
+// tcpPair returns both ends of a loopback TCP connection
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept connection: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return client, server
+}
+
+func TestVerifyHandshakeIdentity(t *testing.T) {
+	identity, err := crypto.NewIdentity()
+	if err != nil {
+		t.Fatalf("failed to create identity: %v", err)
+	}
+	other, err := crypto.NewIdentity()
+	if err != nil {
+		t.Fatalf("failed to create identity: %v", err)
+	}
+
+	// Capture the message a node sends
+	client, server := tcpPair(t)
+	go func() { _ = netp2p.IdentityHandshakeFunc(identity)(netp2p.NewTCPPeer(client, true)) }()
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(server, header); err != nil {
+		t.Fatalf("failed to read handshake: %v", err)
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := io.ReadFull(server, data); err != nil {
+		t.Fatalf("failed to read handshake: %v", err)
+	}
+	msg, err := netp2p.DeserializeHandshakeMessage(data)
+	if err != nil {
+		t.Fatalf("failed to deserialize: %v", err)
+	}
+	if err := netp2p.VerifyHandshakeIdentity(msg); err != nil {
+		t.Fatalf("valid identity rejected: %v", err)
+	}
+
+	// The key travels through serialization
+	roundTrip, err := netp2p.DeserializeHandshakeMessage(netp2p.SerializeHandshakeMessage(msg))
+	if err != nil || !bytes.Equal(roundTrip.PublicKey, msg.PublicKey) || !bytes.Equal(roundTrip.KeySignature, msg.KeySignature) {
+		t.Fatalf("identity lost in serialization: %v", err)
+	}
+
+	// Claiming another node's ID with one's own key fails
+	forged := msg
+	forged.NodeID = other.ID()
+	if netp2p.VerifyHandshakeIdentity(forged) == nil {
+		t.Error("mismatched node ID accepted")
+	}
+
+	// Presenting another node's key without its signature fails
+	forged = msg
+	forged.NodeID = other.ID()
+	forged.PublicKey = other.PublicKey()
+	if netp2p.VerifyHandshakeIdentity(forged) == nil {
+		t.Error("unsigned identity key accepted")
+	}
+
+	// Nodes without an identity still pass
+	if err := netp2p.VerifyHandshakeIdentity(netp2p.HandshakeMessage{NodeID: "legacy-node"}); err != nil {
+		t.Errorf("keyless handshake rejected: %v", err)
+	}
+}
+
+func TestProtocolVersionNegotiation(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max uint8
+		version  uint8
+		fails    bool
+	}{
+		{name: "node predating negotiation", version: netp2p.ProtocolVersion1},
+		{name: "same versions", min: netp2p.MinProtocolVersion, max: netp2p.CurrentProtocolVersion, version: netp2p.CurrentProtocolVersion},
+		{name: "older node", min: 1, max: 1, version: netp2p.ProtocolVersion1},
+		{name: "newer node", min: 1, max: netp2p.CurrentProtocolVersion + 3, version: netp2p.CurrentProtocolVersion},
+		{name: "node that dropped our versions", min: netp2p.CurrentProtocolVersion + 1, max: netp2p.CurrentProtocolVersion + 2, fails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, err := netp2p.NegotiateVersion(netp2p.HandshakeMessage{MinVersion: tt.min, MaxVersion: tt.max})
+			if tt.fails {
+				if err == nil {
+					t.Errorf("expected no common version, got %d", version)
+				}
+				return
+			}
+			if err != nil || version != tt.version {
+				t.Errorf("expected version %d, got %d (%v)", tt.version, version, err)
+			}
+		})
+	}
+
+	// Versions survive serialization without an identity key, and fields a
+	// later version appends are skipped
+	msg := netp2p.HandshakeMessage{NodeID: "node", Timestamp: 1, Signature: []byte("sig"), MinVersion: 1, MaxVersion: 7}
+	data := append(netp2p.SerializeHandshakeMessage(msg), 0xde, 0xad)
+	roundTrip, err := netp2p.DeserializeHandshakeMessage(data)
+	if err != nil {
+		t.Fatalf("failed to deserialize: %v", err)
+	}
+	if roundTrip.MinVersion != 1 || roundTrip.MaxVersion != 7 || roundTrip.PublicKey != nil {
+		t.Errorf("unexpected round trip %+v", roundTrip)
+	}
+
+	// Both ends of a handshake settle on the current version
+	client, server := tcpPair(t)
+	serverPeer := netp2p.NewTCPPeer(server, false)
+	done := make(chan error, 1)
+	go func() { done <- netp2p.AuthenticatedHandshakeFunc("server")(serverPeer) }()
+	clientPeer := netp2p.NewTCPPeer(client, true)
+	if err := netp2p.AuthenticatedHandshakeFunc("client")(clientPeer); err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server handshake failed: %v", err)
+	}
+	if clientPeer.ProtocolVersion() != netp2p.CurrentProtocolVersion || serverPeer.ProtocolVersion() != netp2p.CurrentProtocolVersion {
+		t.Errorf("negotiated versions %d and %d", clientPeer.ProtocolVersion(), serverPeer.ProtocolVersion())
+	}
+	if clientPeer.Codec() != codec.Supported()[0] || serverPeer.Codec() != codec.Supported()[0] {
+		t.Errorf("negotiated codecs %d and %d", clientPeer.Codec(), serverPeer.Codec())
+	}
+}
+
+func TestCodecNegotiation(t *testing.T) {
+	tests := []struct {
+		name    string
+		codecs  []uint8
+		version uint8
+		codec   uint8
+	}{
+		{name: "node predating codecs", version: netp2p.ProtocolVersion3, codec: codec.IDGob},
+		{name: "link on version 2", codecs: []uint8{codec.IDProtobuf}, version: netp2p.ProtocolVersion2, codec: codec.IDGob},
+		{name: "our preference wins", codecs: []uint8{codec.IDGob, codec.IDCBOR, codec.IDProtobuf}, version: netp2p.ProtocolVersion3, codec: codec.IDProtobuf},
+		{name: "common codec", codecs: []uint8{99, codec.IDCBOR}, version: netp2p.ProtocolVersion3, codec: codec.IDCBOR},
+		{name: "no common codec", codecs: []uint8{99}, version: netp2p.ProtocolVersion3, codec: codec.IDGob},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := netp2p.HandshakeMessage{MinVersion: 1, MaxVersion: tt.version, Codecs: tt.codecs}
+			if got := netp2p.NegotiateCodec(msg, tt.version); got != tt.codec {
+				t.Errorf("expected codec %d, got %d", tt.codec, got)
+			}
+		})
+	}
+
+	// Codecs survive serialization
+	msg := netp2p.HandshakeMessage{NodeID: "node", MinVersion: 1, MaxVersion: 3, Codecs: []uint8{codec.IDCBOR, codec.IDGob}}
+	roundTrip, err := netp2p.DeserializeHandshakeMessage(netp2p.SerializeHandshakeMessage(msg))
+	if err != nil || !bytes.Equal(roundTrip.Codecs, msg.Codecs) {
+		t.Errorf("codecs lost in serialization: %v %v", roundTrip.Codecs, err)
+	}
+}
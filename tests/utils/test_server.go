@@ -3,6 +3,7 @@ package utils
 import (
 	"context"
 	"log/slog"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -19,6 +20,9 @@ import (
 type TestServerManager struct {
 	servers map[string]*fs.Server
 	mu      sync.RWMutex
+	// dir holds the servers' storage when set, rather than the working
+	// directory
+	dir string
 }
 
 // NewTestServerManager creates a new test server manager
@@ -43,6 +47,9 @@ func (tsm *TestServerManager) CreateServer(name, listenAddr string, bootstrapNod
 
 	// Create storage root with unique name to avoid conflicts
 	storageRoot := storage.SanitizeStorageRootFromAddr(listenAddr) + "_" + name
+	if tsm.dir != "" {
+		storageRoot = filepath.Join(tsm.dir, storageRoot)
+	}
 
 	// Create server options
 	fileServerOpts := fs.Options{
@@ -141,6 +148,8 @@ func CreateTestNetwork(t *testing.T, config NetworkConfig) *TestServerManager {
 	logging.ConfigureLogger("info")
 
 	manager := NewTestServerManager()
+	// Each run starts from empty stores, and leaves nothing in the tree
+	manager.dir = t.TempDir()
 
 	// Create bootstrap nodes
 	for _, node := range config.BootstrapNodes {